@@ -0,0 +1,253 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/mjl-/mox/accountdel"
+	"github.com/mjl-/mox/admindb"
+	"github.com/mjl-/mox/store"
+)
+
+func cmdAccountTakeout(c *cmd) {
+	c.params = "accountname dst.tgz"
+	c.help = `Write all data of an account to a gzipped tar file.
+
+The takeout archive has the account configuration with its addresses and
+delivery rulesets (account.json), webmail settings, identities and retention
+rules (settings.json), filter rules (filters.json), contacts (contacts.vcf),
+calendar events (calendar.ics) and all mailboxes and messages in maildir format
+(messages/).
+
+If dst.tgz is "-", the archive is written to stdout. An existing file is not
+overwritten.
+
+Users can download the same archive through the account web page, and admins
+through the admin web page.
+`
+	args := c.Parse()
+	if len(args) != 2 {
+		c.Usage()
+	}
+	mustLoadConfig()
+
+	var w io.Writer = os.Stdout
+	if args[1] != "-" {
+		f, err := os.OpenFile(args[1], os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0660)
+		xcheckf(err, "creating destination file")
+		defer func() {
+			err := f.Close()
+			xcheckf(err, "closing destination file")
+		}()
+		w = f
+	}
+	ctlcmdAccountTakeout(xctl(), args[0], w)
+}
+
+func ctlcmdAccountTakeout(ctl *ctl, account string, w io.Writer) {
+	ctl.xwrite("accounttakeout")
+	ctl.xwrite(account)
+	ctl.xreadok()
+	ctl.xstreamto(w)
+	ctl.xreadok()
+}
+
+func accounttakeoutctl(ctx context.Context, ctl *ctl) {
+	/* protocol:
+	> "accounttakeout"
+	> account
+	< "ok" or error
+	< stream, gzipped tar file
+	< "ok" or error
+	*/
+	account := ctl.xread()
+	acc, err := store.OpenAccount(ctl.log, account)
+	ctl.xcheck(err, "opening account")
+	defer func() {
+		err := acc.Close()
+		ctl.log.Check(err, "closing account after takeout")
+	}()
+	ctl.xwriteok()
+
+	w := ctl.writer()
+	gzw := gzip.NewWriter(w)
+	archiver := store.TarArchiver{Writer: tar.NewWriter(gzw)}
+	err = store.Takeout(ctx, ctl.log, acc, archiver)
+	if err == nil {
+		err = archiver.Close()
+	}
+	if err == nil {
+		err = gzw.Close()
+	}
+	w.xclose()
+	ctl.xcheck(err, "writing takeout")
+	ctl.xwriteok()
+}
+
+func cmdAccountDeleteRequest(c *cmd) {
+	c.params = "[-grace duration] accountname"
+	c.help = `Request deletion of an account, purging it after a grace period.
+
+Logins to the account are disabled immediately on all protocols, and IMAP/SMTP
+connections of the account are closed. Incoming messages for the addresses of
+the account are still accepted. During the grace period, the deletion can be
+canceled with "mox account delete cancel", and the data of the account can be
+exported with "mox account takeout".
+
+After the grace period, the account is purged: it is removed from the
+configuration, its data directory is removed, its messages and webhooks in the
+queue (including retired messages and webhooks kept as delivery history) and its
+suppressions are removed, and references to the account in the audit log are
+redacted.
+
+Users can request deletion of their own account through the account web page.
+`
+	grace := accountdel.DefaultGrace
+	c.flag.DurationVar(&grace, "grace", grace, "period before the account is purged, can be 0 for purging the next time pending deletions are processed, or with \"mox account delete purge\"")
+	args := c.Parse()
+	if len(args) != 1 {
+		c.Usage()
+	}
+	mustLoadConfig()
+	ctlcmdAccountDeleteRequest(xctl(), args[0], grace)
+}
+
+func ctlcmdAccountDeleteRequest(ctl *ctl, account string, grace time.Duration) {
+	ctl.xwrite("accountdeleterequest")
+	ctl.xwrite(account)
+	ctl.xwrite(grace.String())
+	ctl.xreadok()
+	purgeAfter := ctl.xread()
+	fmt.Printf("deletion requested, logins disabled, account will be purged after %s\n", purgeAfter)
+}
+
+func cmdAccountDeleteCancel(c *cmd) {
+	c.params = "accountname"
+	c.help = `Cancel a pending deletion of an account, enabling logins again.
+`
+	args := c.Parse()
+	if len(args) != 1 {
+		c.Usage()
+	}
+	mustLoadConfig()
+	ctlcmdAccountDeleteCancel(xctl(), args[0])
+}
+
+func ctlcmdAccountDeleteCancel(ctl *ctl, account string) {
+	ctl.xwrite("accountdeletecancel")
+	ctl.xwrite(account)
+	ctl.xreadok()
+	fmt.Println("deletion canceled, logins enabled")
+}
+
+func cmdAccountDeletePurge(c *cmd) {
+	c.params = "accountname"
+	c.help = `Purge an account with a pending deletion now, without waiting for the end of
+the grace period.
+
+Deletion of the account must have been requested first, with "mox account delete
+request". If the account is still in use, e.g. by a connection that has not
+closed yet, an error is printed and the purge is tried again when pending
+deletions are processed, at least once an hour.
+`
+	args := c.Parse()
+	if len(args) != 1 {
+		c.Usage()
+	}
+	mustLoadConfig()
+	ctlcmdAccountDeletePurge(xctl(), args[0])
+}
+
+func ctlcmdAccountDeletePurge(ctl *ctl, account string) {
+	ctl.xwrite("accountdeletepurge")
+	ctl.xwrite(account)
+	ctl.xreadok()
+	fmt.Println(ctl.xread())
+}
+
+func cmdAccountDeleteList(c *cmd) {
+	c.help = `List accounts with pending deletion.
+`
+	args := c.Parse()
+	if len(args) != 0 {
+		c.Usage()
+	}
+	mustLoadConfig()
+	ctlcmdAccountDeleteList(xctl())
+}
+
+func ctlcmdAccountDeleteList(ctl *ctl) {
+	ctl.xwrite("accountdeletelist")
+	ctl.xreadok()
+	ctl.xstreamto(os.Stdout)
+}
+
+func accountdeletectl(ctx context.Context, ctl *ctl, cmd string) {
+	switch cmd {
+	case "accountdeleterequest":
+		/* protocol:
+		> "accountdeleterequest"
+		> account
+		> grace period, as go duration
+		< "ok" or error
+		< time after which account is purged, in RFC 3339 format
+		*/
+		account := ctl.xread()
+		grace, err := time.ParseDuration(ctl.xread())
+		ctl.xcheck(err, "parsing grace period")
+		d, err := accountdel.Request(ctx, ctl.log, account, "ctl", grace)
+		ctl.xcheck(err, "requesting account deletion")
+		ctl.xwriteok()
+		ctl.xwrite(d.PurgeAfter.Format(time.RFC3339))
+
+	case "accountdeletecancel":
+		/* protocol:
+		> "accountdeletecancel"
+		> account
+		< "ok" or error
+		*/
+		account := ctl.xread()
+		err := accountdel.Cancel(ctx, ctl.log, account)
+		ctl.xcheck(err, "canceling account deletion")
+		ctl.xwriteok()
+
+	case "accountdeletepurge":
+		/* protocol:
+		> "accountdeletepurge"
+		> account
+		< "ok" or error
+		< summary of purged data
+		*/
+		account := ctl.xread()
+		p, err := accountdel.Purge(ctx, ctl.log, account)
+		ctl.xcheck(err, "purging account")
+		ctl.xwriteok()
+		ctl.xwrite(fmt.Sprintf("account purged, removed from queue: %d messages, %d retired messages, %d webhooks, %d retired webhooks, %d suppressions, %d hold rules; redacted %d audit log entries", p.Messages, p.RetiredMessages, p.Hooks, p.RetiredHooks, p.Suppressions, p.HoldRules, p.AuditEntries))
+
+	case "accountdeletelist":
+		/* protocol:
+		> "accountdeletelist"
+		< "ok" or error
+		< stream
+		*/
+		l, err := admindb.DeletionList(ctx)
+		ctl.xcheck(err, "listing pending account deletions")
+		ctl.xwriteok()
+		xw := ctl.writer()
+		if len(l) == 0 {
+			fmt.Fprintln(xw, "(none)")
+		}
+		for _, d := range l {
+			fmt.Fprintf(xw, "%s\trequested %s by %s\tpurge after %s\n", d.Account, d.Requested.Format(time.RFC3339), d.RequestedBy, d.PurgeAfter.Format(time.RFC3339))
+		}
+		xw.xclose()
+
+	default:
+		ctl.xerror("unknown command")
+	}
+}
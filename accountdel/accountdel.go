@@ -0,0 +1,242 @@
+// Package accountdel implements the two-step deletion of accounts, by their
+// owners or by admins.
+//
+// A deletion is first requested, which disables logins to the account and closes
+// its IMAP/SMTP connections. Incoming messages are still accepted. During the
+// grace period, the deletion can be canceled by an admin, and the data of the
+// account can still be exported, e.g. with a takeout archive. After the grace
+// period, the account is purged: it is removed from the configuration, its data
+// directory is removed, its messages, webhooks and suppressions are removed from
+// the queue, including the retired messages and webhooks kept as delivery
+// history, its submission networks and incidents and its quarantined messages are
+// removed, and references to the account in the audit log are redacted.
+package accountdel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"slices"
+	"time"
+
+	"github.com/mjl-/mox/admindb"
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/quarantine"
+	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/store"
+)
+
+// DefaultGrace is the period between the request for deletion and the purge, if
+// no other period is specified.
+const DefaultGrace = 30 * 24 * time.Hour
+
+// LoginDisabledMessage is set as LoginDisabled in the account configuration
+// while deletion is pending.
+const LoginDisabledMessage = "account is scheduled for deletion"
+
+// Interval between checks for deletions past their grace period.
+const interval = time.Hour
+
+// Purged is the result of purging an account.
+type Purged struct {
+	queue.AccountPurged
+	AuditEntries int // Number of redacted audit log entries.
+}
+
+// Request schedules the deletion of an account after the grace period, disabling
+// logins to the account and closing its IMAP/SMTP sessions. The grace period can
+// be zero, for purging the account the next time pending deletions are processed,
+// or immediately with Purge.
+func Request(ctx context.Context, log mlog.Log, account, requestedBy string, grace time.Duration) (admindb.AccountDeletion, error) {
+	conf, ok := mox.Conf.Account(account)
+	if !ok {
+		return admindb.AccountDeletion{}, fmt.Errorf("%w: account does not exist", mox.ErrRequest)
+	} else if grace < 0 {
+		return admindb.AccountDeletion{}, fmt.Errorf("%w: grace period must not be negative", mox.ErrRequest)
+	}
+
+	var addresses []string
+	for addr := range conf.Destinations {
+		addresses = append(addresses, addr)
+	}
+	slices.Sort(addresses)
+
+	now := time.Now()
+	d := admindb.AccountDeletion{
+		Account:     account,
+		Requested:   now,
+		PurgeAfter:  now.Add(grace),
+		RequestedBy: requestedBy,
+		Addresses:   addresses,
+	}
+	if err := admindb.DeletionAdd(ctx, &d); err != nil {
+		return admindb.AccountDeletion{}, err
+	}
+
+	err := mox.AccountSave(ctx, account, func(acc *config.Account) {
+		acc.LoginDisabled = LoginDisabledMessage
+	})
+	if err != nil {
+		xerr := admindb.DeletionRemove(context.WithoutCancel(ctx), account)
+		log.Check(xerr, "removing account deletion after error")
+		return admindb.AccountDeletion{}, fmt.Errorf("disabling logins: %w", err)
+	}
+
+	closeSessions(log, account)
+
+	log.Info("account deletion requested",
+		slog.String("account", account),
+		slog.String("requestedby", requestedBy),
+		slog.Time("purgeafter", d.PurgeAfter))
+	return d, nil
+}
+
+// closeSessions closes the IMAP/SMTP sessions of the account.
+func closeSessions(log mlog.Log, account string) {
+	acc, err := store.OpenAccount(log, account)
+	if err != nil {
+		log.Errorx("open account for closing sessions", err, slog.String("account", account))
+		return
+	}
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+	_, err = acc.ProtocolSessionClose(log, 0)
+	log.Check(err, "closing sessions of account", slog.String("account", account))
+}
+
+// Cancel cancels a pending deletion of an account, enabling logins again.
+func Cancel(ctx context.Context, log mlog.Log, account string) error {
+	if err := admindb.DeletionRemove(ctx, account); err != nil {
+		return err
+	}
+	if conf, ok := mox.Conf.Account(account); ok && conf.LoginDisabled == LoginDisabledMessage {
+		err := mox.AccountSave(ctx, account, func(acc *config.Account) {
+			acc.LoginDisabled = ""
+		})
+		if err != nil {
+			return fmt.Errorf("enabling logins: %w", err)
+		}
+	}
+	log.Info("account deletion canceled", slog.String("account", account))
+	return nil
+}
+
+// Purge purges an account with a pending deletion now, without waiting for the
+// grace period to end. If the account is still in use, e.g. by a connection that
+// hasn't closed yet, an error is returned, and the purge is tried again when
+// pending deletions are processed.
+func Purge(ctx context.Context, log mlog.Log, account string) (Purged, error) {
+	d, err := admindb.DeletionGet(ctx, account)
+	if err != nil {
+		return Purged{}, err
+	}
+	return purge(ctx, log, d)
+}
+
+func purge(ctx context.Context, log mlog.Log, d admindb.AccountDeletion) (Purged, error) {
+	var p Purged
+	var err error
+
+	// Each step can be repeated, we keep the pending deletion until all are done.
+	p.AccountPurged, err = queue.AccountPurge(ctx, log, d.Account)
+	if err != nil {
+		return p, fmt.Errorf("purging queue: %w", err)
+	}
+	p.AuditEntries, err = admindb.AuditRedact(ctx, d.Account, d.Addresses)
+	if err != nil {
+		return p, fmt.Errorf("redacting audit log: %w", err)
+	}
+	if _, err := admindb.SubmissionPurge(ctx, d.Account); err != nil {
+		return p, fmt.Errorf("removing submission networks and incidents: %w", err)
+	}
+	if _, err := quarantine.PurgeAccount(ctx, log, d.Account); err != nil {
+		return p, fmt.Errorf("removing quarantined messages: %w", err)
+	}
+
+	if _, ok := mox.Conf.Account(d.Account); ok {
+		closeSessions(log, d.Account)
+		if err := mox.AccountRemove(ctx, d.Account); err != nil {
+			return p, fmt.Errorf("removing account from configuration: %w", err)
+		}
+	}
+
+	// Closed connections may still be releasing the account.
+	for i := 0; ; i++ {
+		err = store.RemoveAccountData(log, d.Account)
+		if err == nil || i >= 10 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return p, ctx.Err()
+		case <-time.After(time.Second / 2):
+		}
+	}
+	if err != nil {
+		return p, fmt.Errorf("removing account data: %w", err)
+	}
+
+	if err := admindb.DeletionRemove(ctx, d.Account); err != nil && !errors.Is(err, admindb.ErrNotFound) {
+		return p, fmt.Errorf("removing pending deletion: %w", err)
+	}
+	log.Info("account purged", slog.String("account", d.Account), slog.Int("messages", p.Messages), slog.Int("auditentries", p.AuditEntries))
+	return p, nil
+}
+
+// PurgeExpired purges all accounts with pending deletions past their grace
+// period.
+func PurgeExpired(ctx context.Context, log mlog.Log, now time.Time) {
+	l, err := admindb.DeletionList(ctx)
+	if err != nil {
+		log.Errorx("listing pending account deletions", err)
+		return
+	}
+	for _, d := range l {
+		if ctx.Err() != nil || d.PurgeAfter.After(now) {
+			return
+		}
+		purgeSafe(ctx, log, d)
+	}
+}
+
+func purgeSafe(ctx context.Context, log mlog.Log, d admindb.AccountDeletion) {
+	defer func() {
+		x := recover()
+		if x != nil {
+			log.Error("recover from panic", slog.Any("panic", x), slog.String("account", d.Account))
+			debug.PrintStack()
+			metrics.PanicInc(metrics.Accountdel)
+		}
+	}()
+
+	_, err := purge(ctx, log, d)
+	log.Check(err, "purging account", slog.String("account", d.Account))
+}
+
+// Start periodically purges accounts with pending deletions past their grace
+// period, starting a few minutes after startup.
+func Start() {
+	log := mlog.New("accountdel", nil)
+
+	go func() {
+		timer := time.NewTimer(5 * time.Minute)
+		defer timer.Stop()
+		for {
+			select {
+			case <-mox.Shutdown.Done():
+				return
+			case <-timer.C:
+			}
+
+			PurgeExpired(mox.Shutdown, log.WithCid(mox.Cid()), time.Now())
+			timer.Reset(interval)
+		}
+	}()
+}
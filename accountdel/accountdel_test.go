@@ -0,0 +1,196 @@
+package accountdel
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/admindb"
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/store"
+	"github.com/mjl-/mox/webapi"
+)
+
+var ctxbg = context.Background()
+
+func tcheck(t *testing.T, err error, msg string) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %s", msg, err)
+	}
+}
+
+func TestAccountDeletion(t *testing.T) {
+	log := mlog.New("accountdel", nil)
+	os.RemoveAll("../testdata/accountdel/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/accountdel/mox.conf")
+	mox.ConfigDynamicPath = filepath.FromSlash("../testdata/accountdel/domains.conf")
+	mox.MustLoadConfig(true, false)
+	defer store.Switchboard()()
+	err := queue.Init()
+	tcheck(t, err, "queue init")
+	defer queue.Shutdown()
+	err = admindb.Init()
+	tcheck(t, err, "admindb init")
+	defer admindb.Close()
+
+	err = mox.AccountAdd(ctxbg, "del", "del@mox.example")
+	tcheck(t, err, "add account")
+
+	acc, err := store.OpenAccount(log, "del")
+	tcheck(t, err, "open account")
+	err = acc.SetPassword(log, "test1234")
+	tcheck(t, err, "set password")
+	msgFile, err := store.CreateMessageTemp(log, "accountdel-test")
+	tcheck(t, err, "create temp message")
+	defer os.Remove(msgFile.Name())
+	defer msgFile.Close()
+	const msg = "From: <remote@example.org>\r\nSubject: test\r\n\r\nbody\r\n"
+	_, err = msgFile.Write([]byte(msg))
+	tcheck(t, err, "write message")
+	m := store.Message{Received: time.Now(), Size: int64(len(msg))}
+	acc.WithWLock(func() {
+		err = acc.DeliverMailbox(log, "Inbox", &m, msgFile)
+	})
+	tcheck(t, err, "deliver message")
+	err = acc.Close()
+	tcheck(t, err, "close account")
+	acc.CheckClosed()
+
+	login := func() error {
+		t.Helper()
+		acc, err := store.OpenEmailAuth(log, "del@mox.example", "test1234")
+		if err == nil {
+			err := acc.Close()
+			tcheck(t, err, "close account")
+		}
+		return err
+	}
+	tcheck(t, login(), "login before deletion request")
+
+	// Request deletion, logins are disabled.
+	_, err = Request(ctxbg, log, "bogus", "ctl", time.Hour)
+	if !errors.Is(err, mox.ErrRequest) {
+		t.Fatalf("request deletion for unknown account, got err %v, expected ErrRequest", err)
+	}
+	d, err := Request(ctxbg, log, "del", "ctl", time.Hour)
+	tcheck(t, err, "request deletion")
+	if !slices.Equal(d.Addresses, []string{"del@mox.example"}) {
+		t.Fatalf("got addresses %v, expected del@mox.example", d.Addresses)
+	}
+	if err := login(); !errors.Is(err, store.ErrLoginDisabled) || !errors.Is(err, store.ErrUnknownCredentials) {
+		t.Fatalf("login after deletion request, got err %v, expected ErrLoginDisabled", err)
+	}
+	_, err = Request(ctxbg, log, "del", "ctl", time.Hour)
+	if !errors.Is(err, admindb.ErrExists) {
+		t.Fatalf("second deletion request, got err %v, expected ErrExists", err)
+	}
+
+	// Cancel, logins are enabled again.
+	err = Cancel(ctxbg, log, "del")
+	tcheck(t, err, "cancel deletion")
+	tcheck(t, login(), "login after canceled deletion")
+	if err := Cancel(ctxbg, log, "del"); !errors.Is(err, admindb.ErrNotFound) {
+		t.Fatalf("cancel without pending deletion, got err %v, expected ErrNotFound", err)
+	}
+	if _, err := Purge(ctxbg, log, "del"); !errors.Is(err, admindb.ErrNotFound) {
+		t.Fatalf("purge without pending deletion, got err %v, expected ErrNotFound", err)
+	}
+
+	// Data that should be purged, or redacted.
+	_, err = queue.HoldRuleAdd(ctxbg, log, queue.HoldRule{Account: "del"})
+	tcheck(t, err, "add hold rule")
+	err = queue.SuppressionAdd(ctxbg, smtp.Path{Localpart: "remote", IPDomain: dns.IPDomain{Domain: dns.Domain{ASCII: "example.org"}}}, &webapi.Suppression{Account: "del", Manual: true})
+	tcheck(t, err, "add suppression")
+	err = admindb.AuditAdd(ctxbg, &admindb.AuditEntry{Source: "ctl", Action: "setaccountpassword", Params: `["del"]`})
+	tcheck(t, err, "add audit entry")
+	err = admindb.AuditAdd(ctxbg, &admindb.AuditEntry{Source: "webadmin", Action: "AddressAdd", Params: `["del@mox.example","del"]`})
+	tcheck(t, err, "add audit entry")
+
+	_, err = Request(ctxbg, log, "del", "account", time.Hour)
+	tcheck(t, err, "request deletion")
+
+	// Takeout is still possible during the grace period.
+	acc, err = store.OpenAccount(log, "del")
+	tcheck(t, err, "open account")
+	var buf bytes.Buffer
+	archiver := store.TarArchiver{Writer: tar.NewWriter(&buf)}
+	err = store.Takeout(ctxbg, log, acc, archiver)
+	tcheck(t, err, "takeout")
+	err = archiver.Close()
+	tcheck(t, err, "close archiver")
+	err = acc.Close()
+	tcheck(t, err, "close account")
+	acc.CheckClosed()
+	names := map[string]bool{}
+	var nmsgs int
+	tr := tar.NewReader(&buf)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		tcheck(t, err, "read takeout")
+		names[h.Name] = true
+		if h.Typeflag == tar.TypeReg && strings.HasPrefix(h.Name, "messages/Inbox/") {
+			nmsgs++
+		}
+	}
+	for _, name := range []string{"account.json", "settings.json", "filters.json", "contacts.vcf", "calendar.ics"} {
+		if !names[name] {
+			t.Fatalf("missing %s in takeout, got %v", name, names)
+		}
+	}
+	if nmsgs != 1 {
+		t.Fatalf("got %d messages in takeout, expected 1", nmsgs)
+	}
+
+	// Not yet past grace period.
+	now := time.Now()
+	PurgeExpired(ctxbg, log, now)
+	if _, ok := mox.Conf.Account("del"); !ok {
+		t.Fatalf("account purged before end of grace period")
+	}
+
+	PurgeExpired(ctxbg, log, now.Add(2*time.Hour))
+	if _, ok := mox.Conf.Account("del"); ok {
+		t.Fatalf("account still in config after purge")
+	}
+	if _, err := os.Stat(filepath.FromSlash("../testdata/accountdel/data/accounts/del")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("account data directory still present after purge, stat err %v", err)
+	}
+	if _, err := admindb.DeletionGet(ctxbg, "del"); !errors.Is(err, admindb.ErrNotFound) {
+		t.Fatalf("pending deletion still present after purge, err %v", err)
+	}
+	n, err := bstore.QueryDB[queue.HoldRule](ctxbg, queue.DB).FilterNonzero(queue.HoldRule{Account: "del"}).Count()
+	tcheck(t, err, "count hold rules")
+	if n != 0 {
+		t.Fatalf("got %d hold rules after purge, expected 0", n)
+	}
+	n, err = bstore.QueryDB[webapi.Suppression](ctxbg, queue.DB).FilterNonzero(webapi.Suppression{Account: "del"}).Count()
+	tcheck(t, err, "count suppressions")
+	if n != 0 {
+		t.Fatalf("got %d suppressions after purge, expected 0", n)
+	}
+	entries, err := admindb.AuditList(ctxbg, admindb.AuditFilter{})
+	tcheck(t, err, "list audit entries")
+	for _, e := range entries {
+		if strings.Contains(e.Params, "del") {
+			t.Fatalf("audit entry still references account after purge: %s", e.Params)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+// Package accounting records usage per account and domain, for usage reporting
+// and billing, e.g. by those reselling mailboxes on a mox instance.
+//
+// Recorded are the number and total size of incoming messages delivered to an
+// account, of outgoing messages delivered to remote mail servers, i.e. the
+// bandwidth used, and the highest total size of stored messages, sampled hourly.
+// Usage is kept per calendar month in UTC, see admindb.Usage.
+package accounting
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log/slog"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/admindb"
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/store"
+)
+
+// Received records the delivery of an incoming message of size bytes to account,
+// for a recipient address in domain.
+func Received(ctx context.Context, log mlog.Log, account string, domain dns.Domain, size int64) {
+	add(ctx, log, account, domain, admindb.Usage{MessagesReceived: 1, BytesReceived: size})
+}
+
+// Sent records the delivery of an outgoing message of size bytes from account
+// to a remote mail server, for a sender address in domain. For the null sender,
+// domain is zero and the default domain of the account is used.
+func Sent(ctx context.Context, log mlog.Log, account string, domain dns.Domain, size int64) {
+	add(ctx, log, account, domain, admindb.Usage{MessagesSent: 1, BytesSent: size})
+}
+
+// add records usage. Errors are logged, not returned, so delivery continues.
+func add(ctx context.Context, log mlog.Log, account string, domain dns.Domain, delta admindb.Usage) {
+	if account == "" {
+		return
+	}
+	if domain.IsZero() {
+		conf, ok := mox.Conf.Account(account)
+		if !ok {
+			log.Info("not recording usage for unknown account", slog.String("account", account))
+			return
+		}
+		domain = conf.DNSDomain
+	}
+	err := admindb.UsageAdd(ctx, time.Now(), account, domain.Name(), delta)
+	log.Check(err, "recording usage", slog.String("account", account), slog.Any("domain", domain))
+}
+
+// Start periodically records the total size of stored messages of each account.
+func Start() {
+	log := mlog.New("accounting", nil)
+	go func() {
+		timer := time.NewTimer(time.Minute)
+		defer timer.Stop()
+		for {
+			select {
+			case <-mox.Shutdown.Done():
+				return
+			case <-timer.C:
+			}
+
+			sampleAll(log.WithCid(mox.Cid()), time.Now())
+			timer.Reset(time.Hour)
+		}
+	}()
+}
+
+// sampleAll records the total message size of all accounts at time now.
+func sampleAll(log mlog.Log, now time.Time) {
+	defer func() {
+		x := recover()
+		if x != nil {
+			log.Error("recover from panic", slog.Any("panic", x))
+			debug.PrintStack()
+			metrics.PanicInc(metrics.Accounting)
+		}
+	}()
+
+	ctx := mox.Shutdown
+	for _, name := range mox.Conf.Accounts() {
+		if err := sample(ctx, log, name, now); err != nil {
+			log.Errorx("recording stored message size", err, slog.String("account", name))
+		}
+	}
+}
+
+func sample(ctx context.Context, log mlog.Log, name string, now time.Time) error {
+	conf, ok := mox.Conf.Account(name)
+	if !ok {
+		return fmt.Errorf("unknown account")
+	}
+	acc, err := store.OpenAccount(log, name)
+	if err != nil {
+		return fmt.Errorf("open account: %v", err)
+	}
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account after sampling usage")
+	}()
+
+	du := store.DiskUsage{ID: 1}
+	err = acc.DB.Read(ctx, func(tx *bstore.Tx) error {
+		return tx.Get(&du)
+	})
+	if err != nil {
+		return fmt.Errorf("get disk usage: %v", err)
+	}
+	return admindb.UsageAdd(ctx, now, name, conf.DNSDomain.Name(), admindb.Usage{StoredBytes: du.MessageSize})
+}
+
+// Grouping of usage records in List.
+const (
+	GroupAccount = "account" // Per month and account, summed over domains.
+	GroupDomain  = "domain"  // Per month and domain, summed over accounts.
+)
+
+// List returns the usage matching the filter. With group empty, the records are
+// returned as stored, per month, account and domain. With group GroupAccount or
+// GroupDomain, records are summed per month and account, or per month and
+// domain, leaving the other field empty.
+func List(ctx context.Context, f admindb.UsageFilter, group string) ([]admindb.Usage, error) {
+	if group != "" && group != GroupAccount && group != GroupDomain {
+		return nil, fmt.Errorf("%w: unknown grouping %q, must be empty, %q or %q", mox.ErrRequest, group, GroupAccount, GroupDomain)
+	}
+	for _, month := range []string{f.Start, f.End} {
+		if _, err := time.Parse("2006-01", month); month != "" && err != nil {
+			return nil, fmt.Errorf("%w: bad month %q, must be of the form yyyy-mm", mox.ErrRequest, month)
+		}
+	}
+
+	if f.Domain != "" {
+		d, err := dns.ParseDomain(f.Domain)
+		if err != nil {
+			return nil, fmt.Errorf("%w: parsing domain: %v", mox.ErrRequest, err)
+		}
+		f.Domain = d.Name()
+	}
+
+	l, err := admindb.UsageList(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	return Group(l, group), nil
+}
+
+// Group returns usage records summed per month and account for group
+// GroupAccount, or per month and domain for GroupDomain. For other values of
+// group, l is returned as is.
+func Group(l []admindb.Usage, group string) []admindb.Usage {
+	if group != GroupAccount && group != GroupDomain {
+		return l
+	}
+
+	type key struct{ month, name string }
+	index := map[key]int{}
+	var r []admindb.Usage
+	for _, u := range l {
+		k := key{u.Month, u.Account}
+		if group == GroupDomain {
+			k.name = u.Domain
+		}
+		i, ok := index[k]
+		if !ok {
+			i = len(r)
+			index[k] = i
+			n := admindb.Usage{Month: u.Month}
+			if group == GroupAccount {
+				n.Account = u.Account
+			} else {
+				n.Domain = u.Domain
+			}
+			r = append(r, n)
+		}
+		s := &r[i]
+		s.MessagesReceived += u.MessagesReceived
+		s.BytesReceived += u.BytesReceived
+		s.MessagesSent += u.MessagesSent
+		s.BytesSent += u.BytesSent
+		s.StoredBytes += u.StoredBytes
+		if u.Updated.After(s.Updated) {
+			s.Updated = u.Updated
+		}
+	}
+	sort.SliceStable(r, func(i, j int) bool {
+		if r[i].Month != r[j].Month {
+			return r[i].Month < r[j].Month
+		}
+		return r[i].Account+"\x00"+r[i].Domain < r[j].Account+"\x00"+r[j].Domain
+	})
+	return r
+}
+
+// WriteCSV writes usage records as CSV, with a header line.
+func WriteCSV(w io.Writer, l []admindb.Usage) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"month", "account", "domain", "messages_received", "bytes_received", "messages_sent", "bytes_sent", "stored_bytes"}); err != nil {
+		return err
+	}
+	for _, u := range l {
+		f := func(v int64) string { return strconv.FormatInt(v, 10) }
+		if err := cw.Write([]string{u.Month, u.Account, u.Domain, f(u.MessagesReceived), f(u.BytesReceived), f(u.MessagesSent), f(u.BytesSent), f(u.StoredBytes)}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,112 @@
+package accounting
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/admindb"
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/store"
+)
+
+var ctxbg = context.Background()
+
+func tcheck(t *testing.T, err error, msg string) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %s", msg, err)
+	}
+}
+
+func tcompare(t *testing.T, got, exp any) {
+	t.Helper()
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("got:\n%#v\nexpected:\n%#v", got, exp)
+	}
+}
+
+func TestAccounting(t *testing.T) {
+	log := mlog.New("accounting", nil)
+	os.RemoveAll("../testdata/accounting/data")
+	mox.Context = ctxbg
+	mox.Shutdown = ctxbg
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/accounting/mox.conf")
+	mox.ConfigDynamicPath = filepath.FromSlash("../testdata/accounting/domains.conf")
+	mox.MustLoadConfig(true, false)
+	defer store.Switchboard()()
+
+	err := admindb.Init()
+	tcheck(t, err, "admindb init")
+	defer admindb.Close()
+
+	moxExample := dns.Domain{ASCII: "mox.example"}
+	otherExample := dns.Domain{ASCII: "other.example"}
+	Received(ctxbg, log, "mjl", moxExample, 100)
+	Received(ctxbg, log, "mjl", moxExample, 50)
+	Received(ctxbg, log, "mjl", otherExample, 10)
+	Received(ctxbg, log, "other", otherExample, 20)
+	Sent(ctxbg, log, "mjl", moxExample, 1000)
+	Sent(ctxbg, log, "other", dns.Domain{}, 30) // Null sender, for default domain of account.
+	Sent(ctxbg, log, "", moxExample, 30)        // No account, not recorded.
+
+	// Stored bytes keeps the highest sample of the month.
+	now := time.Now()
+	err = admindb.UsageAdd(ctxbg, now, "mjl", "mox.example", admindb.Usage{StoredBytes: 5000})
+	tcheck(t, err, "add usage")
+	sampleAll(log, now)
+
+	month := admindb.UsageMonth(now)
+	strip := func(l []admindb.Usage) []admindb.Usage {
+		for i := range l {
+			l[i].ID = 0
+			l[i].Updated = time.Time{}
+		}
+		return l
+	}
+
+	l, err := List(ctxbg, admindb.UsageFilter{}, "")
+	tcheck(t, err, "list")
+	tcompare(t, strip(l), []admindb.Usage{
+		{Month: month, Account: "mjl", Domain: "mox.example", MessagesReceived: 2, BytesReceived: 150, MessagesSent: 1, BytesSent: 1000, StoredBytes: 5000},
+		{Month: month, Account: "mjl", Domain: "other.example", MessagesReceived: 1, BytesReceived: 10},
+		{Month: month, Account: "other", Domain: "other.example", MessagesReceived: 1, BytesReceived: 20, MessagesSent: 1, BytesSent: 30},
+	})
+
+	l, err = List(ctxbg, admindb.UsageFilter{Start: month, End: month}, GroupAccount)
+	tcheck(t, err, "list per account")
+	tcompare(t, strip(l), []admindb.Usage{
+		{Month: month, Account: "mjl", MessagesReceived: 3, BytesReceived: 160, MessagesSent: 1, BytesSent: 1000, StoredBytes: 5000},
+		{Month: month, Account: "other", MessagesReceived: 1, BytesReceived: 20, MessagesSent: 1, BytesSent: 30},
+	})
+
+	l, err = List(ctxbg, admindb.UsageFilter{Domain: "OTHER.example"}, GroupDomain)
+	tcheck(t, err, "list per domain")
+	tcompare(t, strip(l), []admindb.Usage{
+		{Month: month, Domain: "other.example", MessagesReceived: 2, BytesReceived: 30, MessagesSent: 1, BytesSent: 30},
+	})
+
+	l, err = List(ctxbg, admindb.UsageFilter{Start: "2000-01", End: "2000-12"}, "")
+	tcheck(t, err, "list other months")
+	tcompare(t, len(l), 0)
+
+	_, err = List(ctxbg, admindb.UsageFilter{}, "bogus")
+	if err == nil {
+		t.Fatalf("list with unknown grouping succeeded")
+	}
+	_, err = List(ctxbg, admindb.UsageFilter{Start: "2026"}, "")
+	if err == nil {
+		t.Fatalf("list with bad month succeeded")
+	}
+
+	var b strings.Builder
+	err = WriteCSV(&b, l)
+	tcheck(t, err, "write csv")
+	tcompare(t, b.String(), "month,account,domain,messages_received,bytes_received,messages_sent,bytes_sent,stored_bytes\n")
+}
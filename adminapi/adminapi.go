@@ -0,0 +1,349 @@
+package adminapi
+
+import (
+	"context"
+	"time"
+)
+
+// Methods of the admin API. More methods may be added in the future. See
+// [Client] for documentation.
+type Methods interface {
+	DomainList(ctx context.Context, request DomainListRequest) (response DomainListResult, err error)
+	DomainAdd(ctx context.Context, request DomainAddRequest) (response DomainAddResult, err error)
+	DomainRemove(ctx context.Context, request DomainRemoveRequest) (response DomainRemoveResult, err error)
+	AccountList(ctx context.Context, request AccountListRequest) (response AccountListResult, err error)
+	AccountGet(ctx context.Context, request AccountGetRequest) (response AccountGetResult, err error)
+	AccountAdd(ctx context.Context, request AccountAddRequest) (response AccountAddResult, err error)
+	AccountUpdate(ctx context.Context, request AccountUpdateRequest) (response AccountUpdateResult, err error)
+	AccountPasswordSet(ctx context.Context, request AccountPasswordSetRequest) (response AccountPasswordSetResult, err error)
+	AccountRemove(ctx context.Context, request AccountRemoveRequest) (response AccountRemoveResult, err error)
+	AddressAdd(ctx context.Context, request AddressAddRequest) (response AddressAddResult, err error)
+	AddressRemove(ctx context.Context, request AddressRemoveRequest) (response AddressRemoveResult, err error)
+	AliasList(ctx context.Context, request AliasListRequest) (response AliasListResult, err error)
+	AliasGet(ctx context.Context, request AliasGetRequest) (response AliasGetResult, err error)
+	AliasAdd(ctx context.Context, request AliasAddRequest) (response AliasAddResult, err error)
+	AliasUpdate(ctx context.Context, request AliasUpdateRequest) (response AliasUpdateResult, err error)
+	AliasRemove(ctx context.Context, request AliasRemoveRequest) (response AliasRemoveResult, err error)
+	AliasMembersAdd(ctx context.Context, request AliasMembersAddRequest) (response AliasMembersAddResult, err error)
+	AliasMembersRemove(ctx context.Context, request AliasMembersRemoveRequest) (response AliasMembersRemoveResult, err error)
+	QueueList(ctx context.Context, request QueueListRequest) (response QueueListResult, err error)
+	QueueKick(ctx context.Context, request QueueKickRequest) (response QueueKickResult, err error)
+	QueueHoldSet(ctx context.Context, request QueueHoldSetRequest) (response QueueHoldSetResult, err error)
+	QueueDrop(ctx context.Context, request QueueDropRequest) (response QueueDropResult, err error)
+	AuditList(ctx context.Context, request AuditListRequest) (response AuditListResult, err error)
+	ConfigApply(ctx context.Context, request ConfigApplyRequest) (response ConfigApplyResult, err error)
+	LogLevels(ctx context.Context, request LogLevelsRequest) (response LogLevelsResult, err error)
+	LogLevelSet(ctx context.Context, request LogLevelSetRequest) (response LogLevelSetResult, err error)
+	UsageList(ctx context.Context, request UsageListRequest) (response UsageListResult, err error)
+}
+
+// Error indicates an API-related error.
+type Error struct {
+	// For programmatic handling. Common values: "user" for generic error by user,
+	// "notFound" for references to domains, accounts, addresses or aliases that
+	// don't exist, "forbidden" if the role of the token does not allow the method,
+	// "server" for a server-side processing error, "protocol" for malformed
+	// requests.
+	Code string
+
+	// Human readable error message.
+	Message string
+}
+
+// Error returns the human-readable error message.
+func (e Error) Error() string {
+	return e.Message
+}
+
+// Domain is a configured domain.
+type Domain struct {
+	Name        string // Domain name, with unicode characters for IDNA domains.
+	ASCII       string // Domain name in ASCII form, with "xn--" labels for IDNA domains.
+	Description string
+}
+
+// Account is a configured account.
+type Account struct {
+	Name      string
+	Domain    string   // Default domain for addresses.
+	FullName  string   // Default display name in From header of outgoing messages.
+	Addresses []string // Email addresses delivered to this account, unicode. Catchall addresses start with "@".
+
+	QuotaMessageSize             int64 // Maximum total size of messages in bytes, 0 for the global default, -1 for unlimited.
+	MaxOutgoingMessagesPerDay    int   // 0 for default.
+	MaxFirstTimeRecipientsPerDay int   // 0 for default.
+	DiskUsage                    int64 // Total size of all messages in bytes.
+}
+
+// Alias is an address that forwards messages to its members.
+type Alias struct {
+	Address      string   // Of the alias itself, unicode.
+	Members      []string // Addresses of accounts, unicode.
+	PostPublic   bool     // Whether anyone can send to the alias, instead of only members.
+	ListMembers  bool     // Whether members can see the addresses of other members.
+	AllowMsgFrom bool     // Whether members can send messages with the alias address in the From header.
+	OwnerAddress string   // Local address for delivery failures and moderation, unicode. Empty for postmaster.
+	Moderated    bool     // Whether messages from non-members are held for approval instead of rejected.
+}
+
+type DomainListRequest struct{}
+type DomainListResult struct {
+	Domains []Domain
+}
+
+type DomainAddRequest struct {
+	Domain string // Unicode or ASCII.
+
+	// Account for the postmaster address and DMARC and TLS reports. If the account
+	// doesn't exist yet, it is created with an address with Localpart in the new
+	// domain.
+	Account   string
+	Localpart string // Only for new accounts.
+}
+type DomainAddResult struct{}
+
+type DomainRemoveRequest struct {
+	Domain string
+}
+type DomainRemoveResult struct{}
+
+type AccountListRequest struct{}
+type AccountListResult struct {
+	Accounts []string // Names, sorted.
+}
+
+type AccountGetRequest struct {
+	Account string
+}
+type AccountGetResult struct {
+	Account Account
+}
+
+type AccountAddRequest struct {
+	Account string
+	Address string // Initial address, the domain must exist.
+
+	// If set, the initial password for the account. Otherwise the account can
+	// receive email but not yet log in.
+	Password string
+}
+type AccountAddResult struct{}
+
+// AccountUpdateRequest changes account settings. Fields that are absent/null are
+// not changed.
+type AccountUpdateRequest struct {
+	Account                      string
+	FullName                     *string
+	QuotaMessageSize             *int64
+	MaxOutgoingMessagesPerDay    *int
+	MaxFirstTimeRecipientsPerDay *int
+}
+type AccountUpdateResult struct{}
+
+type AccountPasswordSetRequest struct {
+	Account  string
+	Password string // At least 8 characters.
+}
+type AccountPasswordSetResult struct{}
+
+type AccountRemoveRequest struct {
+	Account string
+}
+type AccountRemoveResult struct{}
+
+type AddressAddRequest struct {
+	Address string // Starting with "@" for a catchall address for the domain.
+	Account string
+}
+type AddressAddResult struct{}
+
+type AddressRemoveRequest struct {
+	Address string
+}
+type AddressRemoveResult struct{}
+
+type AliasListRequest struct {
+	Domain string
+}
+type AliasListResult struct {
+	Aliases []Alias
+}
+
+type AliasGetRequest struct {
+	Address string
+}
+type AliasGetResult struct {
+	Alias Alias
+}
+
+type AliasAddRequest struct {
+	Alias
+}
+type AliasAddResult struct{}
+
+// AliasUpdateRequest changes alias settings. Fields that are absent/null are not
+// changed. Change members with AliasMembersAdd and AliasMembersRemove.
+type AliasUpdateRequest struct {
+	Address      string
+	PostPublic   *bool
+	ListMembers  *bool
+	AllowMsgFrom *bool
+	OwnerAddress *string
+	Moderated    *bool
+}
+type AliasUpdateResult struct{}
+
+type AliasRemoveRequest struct {
+	Address string
+}
+type AliasRemoveResult struct{}
+
+type AliasMembersAddRequest struct {
+	Address string
+	Members []string
+}
+type AliasMembersAddResult struct{}
+
+type AliasMembersRemoveRequest struct {
+	Address string
+	Members []string
+}
+type AliasMembersRemoveResult struct{}
+
+// QueueFilter selects messages in the outgoing queue. Zero values don't filter,
+// an empty filter matches all messages.
+type QueueFilter struct {
+	IDs     []int64
+	Account string // Sending account.
+	From    string // Sender address, or substring of it.
+	To      string // Recipient address, or substring of it.
+	Hold    *bool
+}
+
+// QueueMsg is a message in the outgoing queue, for a single recipient.
+type QueueMsg struct {
+	ID          int64
+	Queued      time.Time
+	Hold        bool // If set, no delivery attempts are made.
+	Account     string
+	From        string
+	To          string
+	Subject     string
+	Size        int64
+	Attempts    int
+	NextAttempt time.Time
+	LastAttempt *time.Time
+	LastError   string // Of the last delivery attempt.
+}
+
+type QueueListRequest struct {
+	Filter QueueFilter
+	Max    int // If > 0, maximum number of messages returned.
+}
+type QueueListResult struct {
+	Messages []QueueMsg
+}
+
+// QueueKickRequest schedules matching messages for immediate delivery.
+type QueueKickRequest struct {
+	Filter QueueFilter
+}
+type QueueKickResult struct {
+	Affected int
+}
+
+type QueueHoldSetRequest struct {
+	Filter QueueFilter
+	Hold   bool // Messages on hold are not delivered until released.
+}
+type QueueHoldSetResult struct {
+	Affected int
+}
+
+// QueueDropRequest removes matching messages from the queue without delivering
+// them and without sending delivery failure notifications.
+type QueueDropRequest struct {
+	Filter QueueFilter
+}
+type QueueDropResult struct {
+	Affected int
+}
+
+// AuditEntry is an administrative action, made through the admin web interface,
+// admin API or command-line.
+type AuditEntry struct {
+	ID       int64
+	Time     time.Time
+	Source   string // "webadmin", "adminapi" or "ctl".
+	Actor    string // Name of API token for adminapi, login for webadmin.
+	RemoteIP string
+	Action   string // Name of method or command, e.g. "AccountAdd".
+	Params   string // Parameters as JSON, or command-line arguments for ctl. Secrets are replaced with "***".
+	Error    string // Empty if the action succeeded.
+}
+
+type AuditListRequest struct {
+	Start  time.Time // If non-zero, only entries at or after this time.
+	End    time.Time // If non-zero, only entries before this time.
+	Source string    // If non-empty, only entries from this source.
+	Max    int       // If > 0, maximum number of entries returned.
+}
+type AuditListResult struct {
+	Entries []AuditEntry // Most recent first.
+}
+
+// ConfigChange is a difference between the running domains.conf and the desired
+// config.
+type ConfigChange struct {
+	Action string // "add", "change" or "remove".
+	Kind   string // "domain", "alias", "account" or "address". For other fields of domains.conf the field name, e.g. "WebHandlers".
+	Name   string // Domain, alias address, account or address. Empty for other fields.
+}
+
+type ConfigApplyRequest struct {
+	Config string // Complete desired domains.conf, in sconf format or as JSON object.
+	DryRun bool   // If set, only validate and return the changes.
+}
+type ConfigApplyResult struct {
+	Changes []ConfigChange
+	Applied bool
+}
+
+type LogLevelsRequest struct{}
+type LogLevelsResult struct {
+	Levels map[string]string // Package name to level, e.g. "error", "info", "debug", "trace". The default level has an empty package name.
+}
+
+// LogLevelSetRequest sets the log level of a package, or the default level if Pkg
+// is empty.
+type LogLevelSetRequest struct {
+	Pkg   string // E.g. smtpserver, queue, imapserver. Empty for the default level.
+	Level string // One of error, info, debug, trace, traceauth, tracedata. Empty to remove the level for Pkg.
+}
+type LogLevelSetResult struct{}
+
+// Usage is the usage of an account for a domain in a month, or the sum for an
+// account or domain in a month.
+type Usage struct {
+	Month            string // In UTC, e.g. "2026-10".
+	Account          string // Empty if summed per domain.
+	Domain           string // Empty if summed per account. Unicode.
+	MessagesReceived int64  // Incoming messages delivered to the account, per recipient.
+	BytesReceived    int64
+	MessagesSent     int64 // Outgoing messages delivered to remote mail servers, per recipient.
+	BytesSent        int64
+	StoredBytes      int64 // Highest sampled total size of messages in the month.
+}
+
+type UsageListRequest struct {
+	Start   string // First month, e.g. "2026-01". Empty for no limit.
+	End     string // Last month, inclusive. Empty for no limit.
+	Account string // If non-empty, only usage for this account.
+	Domain  string // If non-empty, only usage for this domain.
+	Group   string // Empty for usage per month, account and domain. "account" for sums per month and account, "domain" for sums per month and domain.
+	Format  string // Empty or "json" for Usage in the response, "csv" for CSV in the response.
+}
+type UsageListResult struct {
+	Usage []Usage // Sorted by month, account and domain. Empty for format "csv".
+	CSV   string  // With a header line. Only for format "csv".
+}
@@ -0,0 +1,221 @@
+package adminapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Client can be used to call admin API methods.
+// Client implements [Methods].
+type Client struct {
+	BaseURL    string       // For example: http://localhost:1080/adminapi/v0/.
+	Token      string       // Added as "Authorization: Bearer <token>" header if not empty.
+	HTTPClient *http.Client // Optional, defaults to http.DefaultClient.
+}
+
+var _ Methods = Client{}
+
+func (c Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+func transact[T any](ctx context.Context, c Client, fn string, req any) (resp T, rerr error) {
+	reqbuf, err := json.Marshal(req)
+	if err != nil {
+		return resp, fmt.Errorf("marshal request: %v", err)
+	}
+	hreq, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL+fn, bytes.NewReader(reqbuf))
+	if err != nil {
+		return resp, fmt.Errorf("new request: %v", err)
+	}
+	hreq.Header.Set("Content-Type", "application/json")
+	if c.Token != "" {
+		hreq.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	hresp, err := c.httpClient().Do(hreq)
+	if err != nil {
+		return resp, fmt.Errorf("http transaction: %v", err)
+	}
+	defer hresp.Body.Close()
+
+	if hresp.StatusCode == http.StatusOK {
+		err := json.NewDecoder(io.LimitReader(hresp.Body, 10*1024*1024)).Decode(&resp)
+		return resp, err
+	}
+	if hresp.StatusCode != http.StatusBadRequest {
+		return resp, fmt.Errorf("http status %v, expected 200 ok", hresp.Status)
+	}
+	buf, err := io.ReadAll(io.LimitReader(hresp.Body, 10*1024))
+	if err != nil {
+		return resp, fmt.Errorf("reading error from remote: %v", err)
+	}
+	var xerr Error
+	if err := json.Unmarshal(buf, &xerr); err != nil {
+		if len(buf) > 512 {
+			buf = buf[:512]
+		}
+		return resp, fmt.Errorf("error parsing error from remote: %v (first 512 bytes of response: %s)", err, string(buf))
+	}
+	return resp, xerr
+}
+
+// DomainList returns all configured domains.
+func (c Client) DomainList(ctx context.Context, req DomainListRequest) (resp DomainListResult, err error) {
+	return transact[DomainListResult](ctx, c, "DomainList", req)
+}
+
+// DomainAdd adds a new domain, with DKIM keys and defaults for DMARC/TLS reporting
+// and MTA-STS. If the account does not exist, it is created with an address with
+// the localpart in the new domain. For an existing account that isn't the
+// server-wide postmaster account, a postmaster address for the domain is added.
+// DNS records still have to be added to the zone of the domain, see the admin web
+// interface.
+func (c Client) DomainAdd(ctx context.Context, req DomainAddRequest) (resp DomainAddResult, err error) {
+	return transact[DomainAddResult](ctx, c, "DomainAdd", req)
+}
+
+// DomainRemove removes a domain. Accounts are not removed, and addresses of
+// accounts in the domain must be removed first.
+func (c Client) DomainRemove(ctx context.Context, req DomainRemoveRequest) (resp DomainRemoveResult, err error) {
+	return transact[DomainRemoveResult](ctx, c, "DomainRemove", req)
+}
+
+// AccountList returns the names of all accounts.
+func (c Client) AccountList(ctx context.Context, req AccountListRequest) (resp AccountListResult, err error) {
+	return transact[AccountListResult](ctx, c, "AccountList", req)
+}
+
+// AccountGet returns the settings, addresses and disk usage of an account.
+func (c Client) AccountGet(ctx context.Context, req AccountGetRequest) (resp AccountGetResult, err error) {
+	return transact[AccountGetResult](ctx, c, "AccountGet", req)
+}
+
+// AccountAdd adds a new account with an initial address, and optionally an
+// initial password.
+func (c Client) AccountAdd(ctx context.Context, req AccountAddRequest) (resp AccountAddResult, err error) {
+	return transact[AccountAddResult](ctx, c, "AccountAdd", req)
+}
+
+// AccountUpdate changes settings of an account, only those fields that are
+// present in the request.
+func (c Client) AccountUpdate(ctx context.Context, req AccountUpdateRequest) (resp AccountUpdateResult, err error) {
+	return transact[AccountUpdateResult](ctx, c, "AccountUpdate", req)
+}
+
+// AccountPasswordSet sets a new password for an account. Existing sessions stay
+// logged in.
+func (c Client) AccountPasswordSet(ctx context.Context, req AccountPasswordSetRequest) (resp AccountPasswordSetResult, err error) {
+	return transact[AccountPasswordSetResult](ctx, c, "AccountPasswordSet", req)
+}
+
+// AccountRemove removes an account from the configuration. Its data directory
+// with messages is kept on disk.
+func (c Client) AccountRemove(ctx context.Context, req AccountRemoveRequest) (resp AccountRemoveResult, err error) {
+	return transact[AccountRemoveResult](ctx, c, "AccountRemove", req)
+}
+
+// AddressAdd adds an address to an existing account.
+func (c Client) AddressAdd(ctx context.Context, req AddressAddRequest) (resp AddressAddResult, err error) {
+	return transact[AddressAddResult](ctx, c, "AddressAdd", req)
+}
+
+// AddressRemove removes an address from its account. The last address of an
+// account cannot be removed.
+func (c Client) AddressRemove(ctx context.Context, req AddressRemoveRequest) (resp AddressRemoveResult, err error) {
+	return transact[AddressRemoveResult](ctx, c, "AddressRemove", req)
+}
+
+// AliasList returns the aliases of a domain.
+func (c Client) AliasList(ctx context.Context, req AliasListRequest) (resp AliasListResult, err error) {
+	return transact[AliasListResult](ctx, c, "AliasList", req)
+}
+
+// AliasGet returns the settings and members of an alias.
+func (c Client) AliasGet(ctx context.Context, req AliasGetRequest) (resp AliasGetResult, err error) {
+	return transact[AliasGetResult](ctx, c, "AliasGet", req)
+}
+
+// AliasAdd adds a new alias with at least one member.
+func (c Client) AliasAdd(ctx context.Context, req AliasAddRequest) (resp AliasAddResult, err error) {
+	return transact[AliasAddResult](ctx, c, "AliasAdd", req)
+}
+
+// AliasUpdate changes settings of an alias, only those fields that are present in
+// the request.
+func (c Client) AliasUpdate(ctx context.Context, req AliasUpdateRequest) (resp AliasUpdateResult, err error) {
+	return transact[AliasUpdateResult](ctx, c, "AliasUpdate", req)
+}
+
+// AliasRemove removes an alias.
+func (c Client) AliasRemove(ctx context.Context, req AliasRemoveRequest) (resp AliasRemoveResult, err error) {
+	return transact[AliasRemoveResult](ctx, c, "AliasRemove", req)
+}
+
+// AliasMembersAdd adds members to an alias.
+func (c Client) AliasMembersAdd(ctx context.Context, req AliasMembersAddRequest) (resp AliasMembersAddResult, err error) {
+	return transact[AliasMembersAddResult](ctx, c, "AliasMembersAdd", req)
+}
+
+// AliasMembersRemove removes members from an alias. An alias must keep at least
+// one member.
+func (c Client) AliasMembersRemove(ctx context.Context, req AliasMembersRemoveRequest) (resp AliasMembersRemoveResult, err error) {
+	return transact[AliasMembersRemoveResult](ctx, c, "AliasMembersRemove", req)
+}
+
+// QueueList returns messages in the outgoing queue, most recently queued first.
+func (c Client) QueueList(ctx context.Context, req QueueListRequest) (resp QueueListResult, err error) {
+	return transact[QueueListResult](ctx, c, "QueueList", req)
+}
+
+// QueueKick schedules matching messages for immediate delivery attempts.
+func (c Client) QueueKick(ctx context.Context, req QueueKickRequest) (resp QueueKickResult, err error) {
+	return transact[QueueKickResult](ctx, c, "QueueKick", req)
+}
+
+// QueueHoldSet marks matching messages as on hold, or releases them.
+func (c Client) QueueHoldSet(ctx context.Context, req QueueHoldSetRequest) (resp QueueHoldSetResult, err error) {
+	return transact[QueueHoldSetResult](ctx, c, "QueueHoldSet", req)
+}
+
+// QueueDrop removes matching messages from the queue.
+func (c Client) QueueDrop(ctx context.Context, req QueueDropRequest) (resp QueueDropResult, err error) {
+	return transact[QueueDropResult](ctx, c, "QueueDrop", req)
+}
+
+// AuditList returns entries from the audit log of administrative actions.
+func (c Client) AuditList(ctx context.Context, req AuditListRequest) (resp AuditListResult, err error) {
+	return transact[AuditListResult](ctx, c, "AuditList", req)
+}
+
+// ConfigApply replaces domains.conf with a complete desired config, and returns
+// the domains, accounts, addresses and aliases that are added, changed or
+// removed. Applying the same config again makes no changes. DKIM private keys
+// referenced by the config must already exist.
+func (c Client) ConfigApply(ctx context.Context, req ConfigApplyRequest) (resp ConfigApplyResult, err error) {
+	return transact[ConfigApplyResult](ctx, c, "ConfigApply", req)
+}
+
+// LogLevels returns the configured log levels, of the default and per package.
+func (c Client) LogLevels(ctx context.Context, req LogLevelsRequest) (resp LogLevelsResult, err error) {
+	return transact[LogLevelsResult](ctx, c, "LogLevels", req)
+}
+
+// LogLevelSet changes the log level for a package or the default level, until
+// the next restart.
+func (c Client) LogLevelSet(ctx context.Context, req LogLevelSetRequest) (resp LogLevelSetResult, err error) {
+	return transact[LogLevelSetResult](ctx, c, "LogLevelSet", req)
+}
+
+// UsageList returns the monthly usage of accounts and domains: messages received
+// and sent, their sizes, and the total size of stored messages. For reporting and
+// billing.
+func (c Client) UsageList(ctx context.Context, req UsageListRequest) (resp UsageListResult, err error) {
+	return transact[UsageListResult](ctx, c, "UsageList", req)
+}
@@ -0,0 +1,54 @@
+/*
+Package adminapi implements a versioned HTTP/JSON-based API for provisioning
+domains, accounts, addresses and aliases in mox.
+
+The admin web interface uses its own internal API that can change between
+releases. The admin API is meant for automation, e.g. a hosting control panel
+or billing system, and only changes in backwards compatible ways within a
+version.
+
+# Endpoints
+
+The admin API must be enabled in a listener in mox.conf, with AdminAPIHTTP or
+AdminAPIHTTPS. The base URL is /adminapi/v0/ by default, but configurable. It
+serves an introduction that points to this documentation and lists the
+available methods. An HTTP GET on a method shows an example request and
+response.
+
+An HTTP POST to /adminapi/v0/<method> calls a method. The request parameters
+are in the body, as JSON with Content-Type "application/json". Like the webapi,
+requests can also be sent as a form, with the JSON in field "request".
+
+# Authentication
+
+Calls are authenticated with a bearer token, in an "Authorization: Bearer
+<token>" HTTP header. Tokens are managed in the admin web interface, or with the
+mox command-line, e.g. "mox config apitoken add -role domains provisioning". A
+token is only shown when it is created, mox only stores a hash. Failed
+authentication attempts are rate limited per IP.
+
+# Roles
+
+Each token has a role that determines the methods it can call:
+
+  - "readonly": Only methods that don't make changes, e.g. DomainList, AccountGet and QueueList.
+  - "queue": Read-only methods, and managing the outgoing queue.
+  - "domains": Read-only methods, and managing domains, accounts, addresses and aliases.
+  - "admin": All methods, including AuditList.
+
+All changes made through the admin API, the admin web interface and the mox
+command-line are recorded in an append-only audit log.
+
+# Responses
+
+A successful call returns HTTP status 200 with the JSON-encoded result. On
+errors, HTTP status 400 is returned with a JSON-encoded [Error], with a Code
+for programmatic handling, e.g. "user", "notFound", "forbidden", "server" or
+"protocol".
+
+Use [Client] for calling the API from Go. The mox command-line uses the admin
+API for commands run with the -remote flag, e.g. "mox -remote
+https://mail.example.com/adminapi/ queue list", with the token in environment
+variable MOXTOKEN.
+*/
+package adminapi
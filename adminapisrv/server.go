@@ -0,0 +1,926 @@
+// Package adminapisrv implements the server-side of the adminapi.
+package adminapisrv
+
+// In a separate package from adminapi, so adminapi.Client can be used and
+// imported without including all mox internals. Documentation for the functions
+// is in ../adminapi/client.go.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"reflect"
+	"runtime/debug"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/accounting"
+	"github.com/mjl-/mox/adminapi"
+	"github.com/mjl-/mox/admindb"
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/moxio"
+	"github.com/mjl-/mox/moxvar"
+	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/store"
+	"github.com/mjl-/mox/webauth"
+)
+
+var pkglog = mlog.New("adminapi", nil)
+
+var (
+	metricResults = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mox_adminapi_results_total",
+			Help: "HTTP adminapi results by method and result.",
+		},
+		[]string{"method", "result"}, // result: "badauth", "ok", or error code
+	)
+	metricDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "mox_adminapi_duration_seconds",
+			Help:    "HTTP adminapi call duration.",
+			Buckets: []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 20, 30},
+		},
+		[]string{"method"},
+	)
+)
+
+// methodRoles holds the role a token needs for calling a method. Methods not
+// listed require RoleAdmin.
+var methodRoles = map[string]admindb.Role{
+	"DomainList":  admindb.RoleReadonly,
+	"AccountList": admindb.RoleReadonly,
+	"AccountGet":  admindb.RoleReadonly,
+	"AliasList":   admindb.RoleReadonly,
+	"AliasGet":    admindb.RoleReadonly,
+	"QueueList":   admindb.RoleReadonly,
+	"LogLevels":   admindb.RoleReadonly,
+	"UsageList":   admindb.RoleReadonly,
+
+	"DomainAdd":          admindb.RoleDomains,
+	"DomainRemove":       admindb.RoleDomains,
+	"AccountAdd":         admindb.RoleDomains,
+	"AccountUpdate":      admindb.RoleDomains,
+	"AccountPasswordSet": admindb.RoleDomains,
+	"AccountRemove":      admindb.RoleDomains,
+	"AddressAdd":         admindb.RoleDomains,
+	"AddressRemove":      admindb.RoleDomains,
+	"AliasAdd":           admindb.RoleDomains,
+	"AliasUpdate":        admindb.RoleDomains,
+	"AliasRemove":        admindb.RoleDomains,
+	"AliasMembersAdd":    admindb.RoleDomains,
+	"AliasMembersRemove": admindb.RoleDomains,
+
+	"QueueKick":    admindb.RoleQueue,
+	"QueueHoldSet": admindb.RoleQueue,
+	"QueueDrop":    admindb.RoleQueue,
+}
+
+type ctxKey string
+
+var requestInfoCtxKey ctxKey = "requestInfo"
+
+type requestInfo struct {
+	Log   mlog.Log
+	Token admindb.APIToken
+}
+
+var docsMethodTemplate = htmltemplate.Must(htmltemplate.New("method").Parse(`<!doctype html>
+	<head>
+		<meta charset="utf-8" />
+		<meta name="robots" content="noindex,nofollow" />
+		<title>Method {{ .Method }} - Admin API - Mox</title>
+		<style>
+body, html { padding: 1em; font-size: 16px; }
+* { font-size: inherit; font-family: ubuntu, lato, sans-serif; margin: 0; padding: 0; box-sizing: border-box; }
+h1, h2, h3, h4 { margin-bottom: 1ex; }
+h1 { font-size: 1.2rem; }
+h2 { font-size: 1.1rem; }
+h3, h4 { font-size: 1rem; }
+p { margin-bottom: 1em; max-width: 50em; }
+pre { margin-bottom: 1em; padding: 1ex; background-color: #eee; max-width: 50em; overflow-x: auto; font-family: monospace; }
+		</style>
+	</head>
+	<body>
+		<h1><a href="../">Admin API</a> - Method {{ .Method }}</h1>
+		<h2>Example request JSON</h2>
+		<pre>{{ .Request }}</pre>
+		<h2>Example response JSON</h2>
+		<pre>{{ .Response }}</pre>
+		<h2>Example call</h2>
+		<pre>curl -H "Authorization: Bearer $token" -H "Content-Type: application/json" --data-binary @request.json {{ .URL }}</pre>
+	</body>
+</html>
+`))
+
+var docsIndex []byte
+
+func init() {
+	var methods []string
+	mt := reflect.TypeOf((*adminapi.Methods)(nil)).Elem()
+	n := mt.NumMethod()
+	for i := 0; i < n; i++ {
+		methods = append(methods, mt.Method(i).Name)
+	}
+	docsIndexTmpl := htmltemplate.Must(htmltemplate.New("index").Parse(`<!doctype html>
+<html>
+	<head>
+		<meta charset="utf-8" />
+		<meta name="robots" content="noindex,nofollow" />
+		<title>Admin API - Mox</title>
+		<style>
+body, html { padding: 1em; font-size: 16px; }
+* { font-size: inherit; font-family: ubuntu, lato, sans-serif; margin: 0; padding: 0; box-sizing: border-box; }
+h1, h2, h3, h4 { margin-bottom: 1ex; }
+h1 { font-size: 1.2rem; }
+h2 { font-size: 1.1rem; }
+h3, h4 { font-size: 1rem; }
+ul { padding-left: 1rem; }
+p { margin-bottom: 1em; max-width: 50em; }
+		</style>
+	</head>
+	<body>
+		<h1>Admin API</h1>
+		<p>The mox admin API is a versioned HTTP/JSON-based API for provisioning domains, accounts, addresses and aliases, and managing the outgoing queue.</p>
+		<p>Calls are authenticated with a bearer token, created in the admin web interface or with "mox config apitoken add". The role of a token determines which methods it can call.</p>
+		<p>Documentation and examples:</p>
+		<p><a href="{{ .AdminapiDocsURL }}">{{ .AdminapiDocsURL }}</a></p>
+		<h2>Methods</h2>
+		<p>The methods below are available in this version of mox. Follow a link for an example request/response JSON.</p>
+		<ul>
+{{ range $i, $method := .Methods }}
+			<li><a href="{{ $method }}">{{ $method }}</a></li>
+{{ end }}
+		</ul>
+	</body>
+</html>
+`))
+	adminapiDocsURL := "https://pkg.go.dev/github.com/mjl-/mox@" + moxvar.VersionBare + "/adminapi/"
+	indexArgs := struct {
+		AdminapiDocsURL string
+		Methods         []string
+	}{adminapiDocsURL, methods}
+	var b bytes.Buffer
+	err := docsIndexTmpl.Execute(&b, indexArgs)
+	if err != nil {
+		panic("executing api docs index template: " + err.Error())
+	}
+	docsIndex = b.Bytes()
+}
+
+// NewServer returns a new http.Handler for an adminapi server.
+func NewServer(path string, isForwarded bool) http.Handler {
+	return server{path, isForwarded}
+}
+
+// server implements the adminapi methods.
+type server struct {
+	path        string // Path adminapi is configured under, typically /adminapi/, with methods at /adminapi/v0/<method>.
+	isForwarded bool   // Whether incoming requests are reverse-proxied. Used for getting remote IPs for rate limiting.
+}
+
+var _ adminapi.Methods = server{}
+
+// ServeHTTP implements http.Handler.
+func (s server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log := pkglog.WithContext(r.Context()) // Take cid from webserver.
+
+	// Send requests to /adminapi/ to /adminapi/v0/.
+	if r.URL.Path == "/" {
+		if r.Method != "GET" {
+			http.Error(w, "405 - method not allow", http.StatusMethodNotAllowed)
+			return
+		}
+		http.Redirect(w, r, s.path+"v0/", http.StatusSeeOther)
+		return
+	}
+	// Serve short introduction and list to methods at /adminapi/v0/.
+	if r.URL.Path == "/v0/" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(docsIndex)
+		return
+	}
+
+	// Anything else must be a method endpoint.
+	if !strings.HasPrefix(r.URL.Path, "/v0/") {
+		http.NotFound(w, r)
+		return
+	}
+	fn := r.URL.Path[len("/v0/"):]
+	log = log.With(slog.String("method", fn))
+	rfn := reflect.ValueOf(s).MethodByName(fn)
+	var zero reflect.Value
+	if rfn == zero || rfn.Type().NumIn() != 2 || rfn.Type().NumOut() != 2 {
+		log.Debug("unknown adminapi method")
+		http.NotFound(w, r)
+		return
+	}
+
+	// GET on method returns an example request and response JSON. The docs pages
+	// don't require authentication, they don't contain data.
+	if r.Method == "GET" {
+		formatJSON := func(v any) (string, error) {
+			var b bytes.Buffer
+			enc := json.NewEncoder(&b)
+			enc.SetIndent("", "\t")
+			enc.SetEscapeHTML(false)
+			err := enc.Encode(v)
+			return string(b.String()), err
+		}
+
+		req, err := formatJSON(mox.FillExample(nil, reflect.New(rfn.Type().In(1))).Interface())
+		if err != nil {
+			log.Errorx("formatting request as json", err)
+			http.Error(w, "500 - internal server error - marshal request: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		resp, err := formatJSON(mox.FillExample(nil, reflect.New(rfn.Type().Out(0))).Interface())
+		if err != nil {
+			log.Errorx("formatting response as json", err)
+			http.Error(w, "500 - internal server error - marshal response: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		args := struct {
+			Method   string
+			Request  string
+			Response string
+			URL      string
+		}{fn, req, resp, scheme + "://" + r.Host + s.path + "v0/" + fn}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err = docsMethodTemplate.Execute(w, args)
+		log.Check(err, "executing adminapi method template")
+		return
+	} else if r.Method != "POST" {
+		http.Error(w, "405 - method not allowed - use get or post", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token, tok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !tok || token == "" {
+		metricResults.WithLabelValues(fn, "badauth").Inc()
+		log.Debug("missing bearer token")
+		w.Header().Set("WWW-Authenticate", "Bearer realm=adminapi")
+		http.Error(w, "401 - unauthorized - use authorization header with bearer token", http.StatusUnauthorized)
+		return
+	}
+
+	t0 := time.Now()
+
+	// If remote IP/network resulted in too many authentication failures, refuse to serve.
+	remoteIP := webauth.RemoteIP(log, s.isForwarded, r)
+	if remoteIP == nil {
+		metricResults.WithLabelValues(fn, "internal").Inc()
+		log.Debug("cannot find remote ip for rate limiter")
+		http.Error(w, "500 - internal server error - cannot find remote ip", http.StatusInternalServerError)
+		return
+	}
+	if !mox.LimiterFailedAuth.CanAdd(remoteIP, t0, 1) {
+		metrics.AuthenticationRatelimitedInc("adminapi")
+		log.Debug("refusing connection due to many auth failures", slog.Any("remoteip", remoteIP))
+		http.Error(w, "429 - too many auth attempts", http.StatusTooManyRequests)
+		return
+	}
+
+	// Calls that make changes are recorded in the audit log, with their result.
+	var apiToken admindb.APIToken
+	var auditParams string
+	var auditing bool
+	audit := func(errmsg string) {
+		if !auditing {
+			return
+		}
+		auditing = false
+		e := admindb.AuditEntry{
+			Source:   "adminapi",
+			Actor:    apiToken.Name,
+			RemoteIP: remoteIP.String(),
+			Action:   fn,
+			Params:   auditParams,
+			Error:    errmsg,
+		}
+		err := admindb.AuditAdd(context.WithoutCancel(r.Context()), &e)
+		log.Check(err, "adding audit log entry")
+	}
+
+	writeError := func(err adminapi.Error) {
+		audit(err.Message)
+		metricResults.WithLabelValues(fn, err.Code).Inc()
+
+		if err.Code == "server" {
+			log.Errorx("adminapi call result", err, slog.String("resultcode", err.Code))
+		} else {
+			log.Infox("adminapi call result", err, slog.String("resultcode", err.Code))
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusBadRequest)
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		werr := enc.Encode(err)
+		if werr != nil && !moxio.IsClosed(werr) {
+			log.Infox("writing error response", werr)
+		}
+	}
+
+	writeResponse := func(resp any) {
+		audit("")
+		metricResults.WithLabelValues(fn, "ok").Inc()
+		log.Debug("adminapi call result", slog.String("resultcode", "ok"))
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		werr := enc.Encode(resp)
+		if werr != nil && !moxio.IsClosed(werr) {
+			log.Infox("writing error response", werr)
+		}
+	}
+
+	authResult := "error"
+	defer func() {
+		metricDuration.WithLabelValues(fn).Observe(float64(time.Since(t0)) / float64(time.Second))
+		metrics.AuthenticationInc("adminapi", "bearer", authResult)
+	}()
+
+	apiToken, err := admindb.TokenVerify(r.Context(), token)
+	if err != nil {
+		if errors.Is(err, admindb.ErrNotFound) {
+			mox.LimiterFailedAuth.Add(remoteIP, t0, 1)
+			mox.AutoBanFailure(log, remoteIP, mox.AutoBanAuth)
+			log.Debug("unknown bearer token")
+			metricResults.WithLabelValues(fn, "badauth").Inc()
+			authResult = "badcreds"
+			w.Header().Set("WWW-Authenticate", "Bearer realm=adminapi")
+			http.Error(w, "401 - unauthorized - use authorization header with bearer token", http.StatusUnauthorized)
+			return
+		}
+		writeError(adminapi.Error{Code: "server", Message: "error verifying token"})
+		return
+	}
+	authResult = "ok"
+	mox.LimiterFailedAuth.Reset(remoteIP, t0)
+	log = log.With(slog.String("token", apiToken.Name))
+
+	required, ok := methodRoles[fn]
+	if !ok {
+		required = admindb.RoleAdmin
+	}
+	if !apiToken.Role.Allows(required) {
+		writeError(adminapi.Error{Code: "forbidden", Message: fmt.Sprintf("method requires role %q, token has role %q", required, apiToken.Role)})
+		return
+	}
+
+	// Requests are either a JSON body, or a form with a "request" field with JSON, like the webapi.
+	var reqbuf []byte
+	ct, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		writeError(adminapi.Error{Code: "protocol", Message: "unknown content-type " + r.Header.Get("Content-Type")})
+		return
+	}
+	if ct == "application/json" {
+		reqbuf, err = io.ReadAll(http.MaxBytesReader(w, r.Body, 1024*1024))
+		if err != nil {
+			writeError(adminapi.Error{Code: "protocol", Message: "reading request: " + err.Error()})
+			return
+		}
+	} else {
+		if ct == "multipart/form-data" {
+			err = r.ParseMultipartForm(200 * 1024)
+		} else {
+			err = r.ParseForm()
+		}
+		if err != nil {
+			writeError(adminapi.Error{Code: "protocol", Message: "parsing form: " + err.Error()})
+			return
+		}
+		reqbuf = []byte(r.PostFormValue("request"))
+	}
+	if len(reqbuf) == 0 {
+		writeError(adminapi.Error{Code: "protocol", Message: "missing/empty request"})
+		return
+	}
+
+	defer func() {
+		x := recover()
+		if x == nil {
+			return
+		}
+		if err, eok := x.(adminapi.Error); eok {
+			writeError(err)
+			return
+		}
+		log.Error("unhandled panic in adminapi call", slog.Any("x", x), slog.String("resultcode", "server"))
+		metrics.PanicInc(metrics.Adminapi)
+		debug.PrintStack()
+		writeError(adminapi.Error{Code: "server", Message: "unhandled error"})
+	}()
+	req := reflect.New(rfn.Type().In(1))
+	dec := json.NewDecoder(bytes.NewReader(reqbuf))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(req.Interface()); err != nil {
+		writeError(adminapi.Error{Code: "protocol", Message: fmt.Sprintf("parsing request: %s", err)})
+		return
+	}
+	if required != admindb.RoleReadonly && fn != "AuditList" {
+		auditParams = redactedJSON(req.Elem())
+		auditing = true
+	}
+
+	reqInfo := requestInfo{log, apiToken}
+	nctx := context.WithValue(r.Context(), requestInfoCtxKey, reqInfo)
+	resp := rfn.Call([]reflect.Value{reflect.ValueOf(nctx), req.Elem()})
+	if !resp[1].IsZero() {
+		var e adminapi.Error
+		err := resp[1].Interface().(error)
+		if x, eok := err.(adminapi.Error); eok {
+			e = x
+		} else {
+			e = adminapi.Error{Code: "error", Message: err.Error()}
+		}
+		writeError(e)
+		return
+	}
+	rv, _ := mox.FillNil(resp[0])
+	writeResponse(rv.Interface())
+}
+
+// redactedJSON returns request v as JSON for the audit log, with a non-empty
+// Password field replaced by "***", and a Config field replaced by its size.
+func redactedJSON(v reflect.Value) string {
+	nv := reflect.New(v.Type()).Elem()
+	nv.Set(v)
+	if f := nv.FieldByName("Password"); f.IsValid() && f.Kind() == reflect.String && f.String() != "" {
+		f.SetString("***")
+	}
+	if f := nv.FieldByName("Config"); f.IsValid() && f.Kind() == reflect.String && f.String() != "" {
+		f.SetString(fmt.Sprintf("(%d bytes)", len(f.String())))
+	}
+	buf, err := json.Marshal(nv.Interface())
+	if err != nil {
+		return fmt.Sprintf("(marshal error: %v)", err)
+	}
+	return string(buf)
+}
+
+// xcheckf panics with a user error if err is the result of a bad request or
+// would result in an invalid configuration, and with a server error otherwise.
+func xcheckf(err error, format string, args ...any) {
+	if err != nil {
+		msg := fmt.Sprintf(format, args...)
+		code := "server"
+		if errors.Is(err, mox.ErrRequest) || errors.Is(err, mox.ErrConfig) {
+			code = "user"
+		}
+		panic(adminapi.Error{Code: code, Message: fmt.Sprintf("%s: %s", msg, err)})
+	}
+}
+
+func xcheckuserf(err error, format string, args ...any) {
+	if err != nil {
+		msg := fmt.Sprintf(format, args...)
+		panic(adminapi.Error{Code: "user", Message: fmt.Sprintf("%s: %s", msg, err)})
+	}
+}
+
+func xnotfoundf(format string, args ...any) {
+	panic(adminapi.Error{Code: "notFound", Message: fmt.Sprintf(format, args...)})
+}
+
+// xdomain parses a domain name and ensures it is configured.
+func xdomain(s string) (dns.Domain, config.Domain) {
+	d, err := dns.ParseDomain(s)
+	xcheckuserf(err, "parsing domain")
+	dc, ok := mox.Conf.Domain(d)
+	if !ok {
+		xnotfoundf("domain %q not found", s)
+	}
+	return d, dc
+}
+
+// xaccount ensures the account exists.
+func xaccount(name string) config.Account {
+	if name == "" {
+		xcheckuserf(errors.New("account name required"), "checking request")
+	}
+	acc, ok := mox.Conf.Account(name)
+	if !ok {
+		xnotfoundf("account %q not found", name)
+	}
+	return acc
+}
+
+// xalias parses an address and ensures it is an existing alias.
+func xalias(s string) (smtp.Address, config.Alias) {
+	addr, err := smtp.ParseAddress(s)
+	xcheckuserf(err, "parsing alias address")
+	_, dc := xdomain(addr.Domain.Name())
+	a, ok := dc.Aliases[addr.Localpart.String()]
+	if !ok {
+		xnotfoundf("alias %q not found", s)
+	}
+	return addr, a
+}
+
+func xcheckpassword(ctx context.Context, log mlog.Log, account, pw string) {
+	err := mox.PasswordPolicyCheck(ctx, log, account, pw)
+	if errors.Is(err, mox.ErrPasswordPolicy) {
+		xcheckuserf(err, "checking password")
+	}
+	xcheckf(err, "checking password")
+}
+
+func xsetPassword(log mlog.Log, account, password string) {
+	acc, err := store.OpenAccount(log, account)
+	xcheckf(err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+	err = acc.SetPassword(log, password)
+	xcheckf(err, "setting password")
+}
+
+func apiAlias(addr smtp.Address, a config.Alias) adminapi.Alias {
+	return adminapi.Alias{
+		Address:      addr.String(),
+		Members:      slices.Clone(a.Addresses),
+		PostPublic:   a.PostPublic,
+		ListMembers:  a.ListMembers,
+		AllowMsgFrom: a.AllowMsgFrom,
+		OwnerAddress: a.OwnerAddress,
+		Moderated:    a.Moderated,
+	}
+}
+
+func (s server) DomainList(ctx context.Context, req adminapi.DomainListRequest) (resp adminapi.DomainListResult, err error) {
+	for _, name := range mox.Conf.Domains() {
+		d, err := dns.ParseDomain(name)
+		xcheckf(err, "parsing configured domain")
+		dc, _ := mox.Conf.Domain(d)
+		resp.Domains = append(resp.Domains, adminapi.Domain{Name: d.Name(), ASCII: d.ASCII, Description: dc.Description})
+	}
+	return
+}
+
+func (s server) DomainAdd(ctx context.Context, req adminapi.DomainAddRequest) (resp adminapi.DomainAddResult, err error) {
+	d, err := dns.ParseDomain(req.Domain)
+	xcheckuserf(err, "parsing domain")
+	var lp smtp.Localpart
+	if req.Localpart != "" {
+		lp, err = smtp.ParseLocalpart(req.Localpart)
+		xcheckuserf(err, "parsing localpart")
+	}
+	err = mox.DomainAdd(ctx, d, req.Account, lp)
+	xcheckf(err, "adding domain")
+	return
+}
+
+func (s server) DomainRemove(ctx context.Context, req adminapi.DomainRemoveRequest) (resp adminapi.DomainRemoveResult, err error) {
+	d, _ := xdomain(req.Domain)
+	err = mox.DomainRemove(ctx, d)
+	xcheckf(err, "removing domain")
+	return
+}
+
+func (s server) AccountList(ctx context.Context, req adminapi.AccountListRequest) (resp adminapi.AccountListResult, err error) {
+	resp.Accounts = mox.Conf.Accounts()
+	sort.Strings(resp.Accounts)
+	return
+}
+
+func (s server) AccountGet(ctx context.Context, req adminapi.AccountGetRequest) (resp adminapi.AccountGetResult, err error) {
+	log := ctx.Value(requestInfoCtxKey).(requestInfo).Log
+
+	xaccount(req.Account)
+
+	acc, err := store.OpenAccount(log, req.Account)
+	xcheckf(err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	var ac config.Account
+	var diskUsage int64
+	acc.WithRLock(func() {
+		ac, _ = mox.Conf.Account(acc.Name)
+
+		err := acc.DB.Read(ctx, func(tx *bstore.Tx) error {
+			du := store.DiskUsage{ID: 1}
+			err := tx.Get(&du)
+			diskUsage = du.MessageSize
+			return err
+		})
+		xcheckf(err, "get disk usage")
+	})
+
+	addrs := []string{}
+	for addr := range ac.Destinations {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	resp.Account = adminapi.Account{
+		Name:                         req.Account,
+		Domain:                       ac.Domain,
+		FullName:                     ac.FullName,
+		Addresses:                    addrs,
+		QuotaMessageSize:             ac.QuotaMessageSize,
+		MaxOutgoingMessagesPerDay:    ac.MaxOutgoingMessagesPerDay,
+		MaxFirstTimeRecipientsPerDay: ac.MaxFirstTimeRecipientsPerDay,
+		DiskUsage:                    diskUsage,
+	}
+	return
+}
+
+func (s server) AccountAdd(ctx context.Context, req adminapi.AccountAddRequest) (resp adminapi.AccountAddResult, err error) {
+	log := ctx.Value(requestInfoCtxKey).(requestInfo).Log
+
+	if req.Password != "" {
+		xcheckpassword(ctx, log, req.Account, req.Password)
+	}
+	err = mox.AccountAdd(ctx, req.Account, req.Address)
+	xcheckf(err, "adding account")
+	if req.Password != "" {
+		xsetPassword(log, req.Account, req.Password)
+	}
+	return
+}
+
+func (s server) AccountUpdate(ctx context.Context, req adminapi.AccountUpdateRequest) (resp adminapi.AccountUpdateResult, err error) {
+	xaccount(req.Account)
+	err = mox.AccountSave(ctx, req.Account, func(acc *config.Account) {
+		if req.FullName != nil {
+			acc.FullName = *req.FullName
+		}
+		if req.QuotaMessageSize != nil {
+			acc.QuotaMessageSize = *req.QuotaMessageSize
+		}
+		if req.MaxOutgoingMessagesPerDay != nil {
+			acc.MaxOutgoingMessagesPerDay = *req.MaxOutgoingMessagesPerDay
+		}
+		if req.MaxFirstTimeRecipientsPerDay != nil {
+			acc.MaxFirstTimeRecipientsPerDay = *req.MaxFirstTimeRecipientsPerDay
+		}
+	})
+	xcheckf(err, "saving account settings")
+	return
+}
+
+func (s server) AccountPasswordSet(ctx context.Context, req adminapi.AccountPasswordSetRequest) (resp adminapi.AccountPasswordSetResult, err error) {
+	log := ctx.Value(requestInfoCtxKey).(requestInfo).Log
+
+	xaccount(req.Account)
+	xcheckpassword(ctx, log, req.Account, req.Password)
+	xsetPassword(log, req.Account, req.Password)
+	return
+}
+
+func (s server) AccountRemove(ctx context.Context, req adminapi.AccountRemoveRequest) (resp adminapi.AccountRemoveResult, err error) {
+	xaccount(req.Account)
+	err = mox.AccountRemove(ctx, req.Account)
+	xcheckf(err, "removing account")
+	return
+}
+
+func (s server) AddressAdd(ctx context.Context, req adminapi.AddressAddRequest) (resp adminapi.AddressAddResult, err error) {
+	xaccount(req.Account)
+	err = mox.AddressAdd(ctx, req.Address, req.Account)
+	xcheckf(err, "adding address")
+	return
+}
+
+func (s server) AddressRemove(ctx context.Context, req adminapi.AddressRemoveRequest) (resp adminapi.AddressRemoveResult, err error) {
+	err = mox.AddressRemove(ctx, req.Address)
+	xcheckf(err, "removing address")
+	return
+}
+
+func (s server) AliasList(ctx context.Context, req adminapi.AliasListRequest) (resp adminapi.AliasListResult, err error) {
+	d, dc := xdomain(req.Domain)
+	resp.Aliases = []adminapi.Alias{}
+	for lpstr, a := range dc.Aliases {
+		lp, err := smtp.ParseLocalpart(lpstr)
+		xcheckf(err, "parsing configured alias localpart")
+		resp.Aliases = append(resp.Aliases, apiAlias(smtp.NewAddress(lp, d), a))
+	}
+	sort.Slice(resp.Aliases, func(i, j int) bool {
+		return resp.Aliases[i].Address < resp.Aliases[j].Address
+	})
+	return
+}
+
+func (s server) AliasGet(ctx context.Context, req adminapi.AliasGetRequest) (resp adminapi.AliasGetResult, err error) {
+	addr, a := xalias(req.Address)
+	resp.Alias = apiAlias(addr, a)
+	return
+}
+
+func (s server) AliasAdd(ctx context.Context, req adminapi.AliasAddRequest) (resp adminapi.AliasAddResult, err error) {
+	addr, err := smtp.ParseAddress(req.Address)
+	xcheckuserf(err, "parsing alias address")
+	xdomain(addr.Domain.Name())
+	if len(req.Members) == 0 {
+		xcheckuserf(errors.New("at least one member required"), "checking request")
+	}
+	alias := config.Alias{
+		Addresses:    req.Members,
+		PostPublic:   req.PostPublic,
+		ListMembers:  req.ListMembers,
+		AllowMsgFrom: req.AllowMsgFrom,
+		OwnerAddress: req.OwnerAddress,
+		Moderated:    req.Moderated,
+	}
+	err = mox.AliasAdd(ctx, addr, alias)
+	xcheckf(err, "adding alias")
+	return
+}
+
+func (s server) AliasUpdate(ctx context.Context, req adminapi.AliasUpdateRequest) (resp adminapi.AliasUpdateResult, err error) {
+	addr, a := xalias(req.Address)
+	if req.PostPublic != nil {
+		a.PostPublic = *req.PostPublic
+	}
+	if req.ListMembers != nil {
+		a.ListMembers = *req.ListMembers
+	}
+	if req.AllowMsgFrom != nil {
+		a.AllowMsgFrom = *req.AllowMsgFrom
+	}
+	if req.OwnerAddress != nil {
+		a.OwnerAddress = *req.OwnerAddress
+	}
+	if req.Moderated != nil {
+		a.Moderated = *req.Moderated
+	}
+	err = mox.AliasUpdate(ctx, addr, a)
+	xcheckf(err, "saving alias")
+	return
+}
+
+func (s server) AliasRemove(ctx context.Context, req adminapi.AliasRemoveRequest) (resp adminapi.AliasRemoveResult, err error) {
+	addr, _ := xalias(req.Address)
+	err = mox.AliasRemove(ctx, addr)
+	xcheckf(err, "removing alias")
+	return
+}
+
+func (s server) AliasMembersAdd(ctx context.Context, req adminapi.AliasMembersAddRequest) (resp adminapi.AliasMembersAddResult, err error) {
+	addr, _ := xalias(req.Address)
+	err = mox.AliasAddressesAdd(ctx, addr, req.Members)
+	xcheckf(err, "adding members to alias")
+	return
+}
+
+func (s server) AliasMembersRemove(ctx context.Context, req adminapi.AliasMembersRemoveRequest) (resp adminapi.AliasMembersRemoveResult, err error) {
+	addr, _ := xalias(req.Address)
+	err = mox.AliasAddressesRemove(ctx, addr, req.Members)
+	xcheckf(err, "removing members from alias")
+	return
+}
+
+func queueFilter(f adminapi.QueueFilter) queue.Filter {
+	return queue.Filter{IDs: f.IDs, Account: f.Account, From: f.From, To: f.To, Hold: f.Hold}
+}
+
+func (s server) QueueList(ctx context.Context, req adminapi.QueueListRequest) (resp adminapi.QueueListResult, err error) {
+	filter := queueFilter(req.Filter)
+	filter.Max = req.Max
+	l, err := queue.List(ctx, filter, queue.Sort{Field: "Queued"})
+	xcheckf(err, "listing messages in queue")
+	resp.Messages = []adminapi.QueueMsg{}
+	for _, m := range l {
+		resp.Messages = append(resp.Messages, adminapi.QueueMsg{
+			ID:          m.ID,
+			Queued:      m.Queued,
+			Hold:        m.Hold,
+			Account:     m.SenderAccount,
+			From:        m.Sender().XString(true),
+			To:          m.Recipient().XString(true),
+			Subject:     m.Subject,
+			Size:        m.Size,
+			Attempts:    m.Attempts,
+			NextAttempt: m.NextAttempt,
+			LastAttempt: m.LastAttempt,
+			LastError:   m.LastResult().Error,
+		})
+	}
+	return
+}
+
+func (s server) QueueKick(ctx context.Context, req adminapi.QueueKickRequest) (resp adminapi.QueueKickResult, err error) {
+	resp.Affected, err = queue.NextAttemptSet(ctx, queueFilter(req.Filter), time.Now())
+	xcheckf(err, "scheduling delivery of messages in queue")
+	return
+}
+
+func (s server) QueueHoldSet(ctx context.Context, req adminapi.QueueHoldSetRequest) (resp adminapi.QueueHoldSetResult, err error) {
+	resp.Affected, err = queue.HoldSet(ctx, queueFilter(req.Filter), req.Hold)
+	xcheckf(err, "changing hold for messages in queue")
+	return
+}
+
+func (s server) QueueDrop(ctx context.Context, req adminapi.QueueDropRequest) (resp adminapi.QueueDropResult, err error) {
+	log := ctx.Value(requestInfoCtxKey).(requestInfo).Log
+	resp.Affected, err = queue.Drop(ctx, log, queueFilter(req.Filter))
+	xcheckf(err, "dropping messages from queue")
+	return
+}
+
+func (s server) AuditList(ctx context.Context, req adminapi.AuditListRequest) (resp adminapi.AuditListResult, err error) {
+	l, err := admindb.AuditList(ctx, admindb.AuditFilter{Start: req.Start, End: req.End, Source: req.Source, Max: req.Max})
+	xcheckf(err, "listing audit log")
+	resp.Entries = []adminapi.AuditEntry{}
+	for _, e := range l {
+		resp.Entries = append(resp.Entries, adminapi.AuditEntry(e))
+	}
+	return
+}
+
+func (s server) ConfigApply(ctx context.Context, req adminapi.ConfigApplyRequest) (resp adminapi.ConfigApplyResult, err error) {
+	log := ctx.Value(requestInfoCtxKey).(requestInfo).Log
+	changes, err := mox.ConfigApply(ctx, log, []byte(req.Config), req.DryRun)
+	xcheckf(err, "applying config")
+	resp.Changes = []adminapi.ConfigChange{}
+	for _, c := range changes {
+		resp.Changes = append(resp.Changes, adminapi.ConfigChange(c))
+	}
+	resp.Applied = !req.DryRun
+	return
+}
+
+func (s server) LogLevels(ctx context.Context, req adminapi.LogLevelsRequest) (resp adminapi.LogLevelsResult, err error) {
+	resp.Levels = map[string]string{}
+	for pkg, level := range mox.Conf.LogLevels() {
+		resp.Levels[pkg] = mlog.LevelStrings[level]
+	}
+	return
+}
+
+func (s server) LogLevelSet(ctx context.Context, req adminapi.LogLevelSetRequest) (resp adminapi.LogLevelSetResult, err error) {
+	log := ctx.Value(requestInfoCtxKey).(requestInfo).Log
+	if req.Level == "" {
+		if req.Pkg == "" {
+			xcheckuserf(errors.New("default log level cannot be removed"), "checking request")
+		}
+		mox.Conf.LogLevelRemove(log, req.Pkg)
+		return
+	}
+	level, ok := mlog.Levels[req.Level]
+	if !ok {
+		xcheckuserf(fmt.Errorf("unknown level %q", req.Level), "checking request")
+	}
+	mox.Conf.LogLevelSet(log, req.Pkg, level)
+	return
+}
+
+func (s server) UsageList(ctx context.Context, req adminapi.UsageListRequest) (resp adminapi.UsageListResult, err error) {
+	if req.Format != "" && req.Format != "json" && req.Format != "csv" {
+		xcheckuserf(fmt.Errorf("unknown format %q, must be empty, json or csv", req.Format), "checking request")
+	}
+	l, err := accounting.List(ctx, admindb.UsageFilter{Start: req.Start, End: req.End, Account: req.Account, Domain: req.Domain}, req.Group)
+	xcheckf(err, "listing usage")
+	resp.Usage = []adminapi.Usage{}
+	if req.Format == "csv" {
+		var b strings.Builder
+		err = accounting.WriteCSV(&b, l)
+		xcheckf(err, "writing csv")
+		resp.CSV = b.String()
+		return
+	}
+	for _, u := range l {
+		resp.Usage = append(resp.Usage, adminapi.Usage{
+			Month:            u.Month,
+			Account:          u.Account,
+			Domain:           u.Domain,
+			MessagesReceived: u.MessagesReceived,
+			BytesReceived:    u.BytesReceived,
+			MessagesSent:     u.MessagesSent,
+			BytesSent:        u.BytesSent,
+			StoredBytes:      u.StoredBytes,
+		})
+	}
+	return
+}
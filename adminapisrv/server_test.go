@@ -0,0 +1,417 @@
+package adminapisrv
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/adminapi"
+	"github.com/mjl-/mox/admindb"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/store"
+)
+
+var ctxbg = context.Background()
+
+func tcheckf(t *testing.T, err error, format string, args ...any) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %s", fmt.Sprintf(format, args...), err)
+	}
+}
+
+func tcompare(t *testing.T, got, expect any) {
+	t.Helper()
+	if !reflect.DeepEqual(got, expect) {
+		t.Fatalf("got:\n%#v\nexpected:\n%#v", got, expect)
+	}
+}
+
+func terrcode(t *testing.T, err error, code string) {
+	t.Helper()
+	if err == nil {
+		t.Fatalf("no error, expected error with code %q", code)
+	}
+	if xerr, ok := err.(adminapi.Error); !ok {
+		t.Fatalf("got %v, expected adminapi error with code %q", err, code)
+	} else if xerr.Code != code {
+		t.Fatalf("got error code %q (%s), expected %q", xerr.Code, xerr.Message, code)
+	}
+}
+
+func TestServer(t *testing.T) {
+	mox.LimitersInit()
+	os.RemoveAll("../testdata/adminapisrv/data")
+	// DKIM keys are generated when adding a domain, and moved away when removing it.
+	os.RemoveAll("../testdata/adminapisrv/dkim")
+	defer os.RemoveAll("../testdata/adminapisrv/dkim")
+	mox.Context = ctxbg
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/adminapisrv/mox.conf")
+	mox.ConfigDynamicPath = filepath.Join(filepath.Dir(mox.ConfigStaticPath), "domains.conf")
+	mox.MustLoadConfig(true, false)
+	defer store.Switchboard()()
+	err := admindb.Init()
+	tcheckf(t, err, "admindb init")
+	defer admindb.Close()
+
+	err = queue.Init()
+	tcheckf(t, err, "queue init")
+	defer queue.Shutdown()
+
+	token, _, err := admindb.TokenAdd(ctxbg, "test", admindb.RoleAdmin)
+	tcheckf(t, err, "add token")
+	_, _, err = admindb.TokenAdd(ctxbg, "test", admindb.RoleAdmin)
+	if err == nil {
+		t.Fatalf("adding token with duplicate name succeeded")
+	}
+	_, _, err = admindb.TokenAdd(ctxbg, "bogus", admindb.Role("bogus"))
+	if err == nil {
+		t.Fatalf("adding token with unknown role succeeded")
+	}
+
+	s := NewServer("/adminapi/", false).(server)
+	hs := httptest.NewServer(s)
+	defer hs.Close()
+
+	// server expects the mount path to be stripped already.
+	client := adminapi.Client{BaseURL: hs.URL + "/v0/", Token: token}
+
+	testHTTP := func(method, path string, headers map[string]string, body string, expCode int, expErrCode string) {
+		t.Helper()
+
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		res := w.Result()
+		tcompare(t, res.StatusCode, expCode)
+		if expErrCode != "" {
+			var apierr adminapi.Error
+			err := json.NewDecoder(res.Body).Decode(&apierr)
+			tcheckf(t, err, "decoding json error")
+			tcompare(t, apierr.Code, expErrCode)
+		}
+	}
+
+	testHTTP("GET", "/", nil, "", http.StatusSeeOther, "")
+	testHTTP("POST", "/", nil, "", http.StatusMethodNotAllowed, "")
+	testHTTP("GET", "/v0/", nil, "", http.StatusOK, "")
+	testHTTP("GET", "/other", nil, "", http.StatusNotFound, "")
+	testHTTP("GET", "/v0/Bogus", nil, "", http.StatusNotFound, "")
+	testHTTP("GET", "/v0/AccountAdd", nil, "", http.StatusOK, "")
+	testHTTP("PUT", "/v0/AccountAdd", nil, "", http.StatusMethodNotAllowed, "")
+
+	// Missing and bad tokens.
+	testHTTP("POST", "/v0/DomainList", nil, "{}", http.StatusUnauthorized, "")
+	testHTTP("POST", "/v0/DomainList", map[string]string{"Authorization": "Basic bWpsOnRlc3Q="}, "{}", http.StatusUnauthorized, "")
+	testHTTP("POST", "/v0/DomainList", map[string]string{"Authorization": "Bearer bogus"}, "{}", http.StatusUnauthorized, "")
+	mox.LimitersInit() // Reset rate limiter.
+
+	auth := map[string]string{"Authorization": "Bearer " + token, "Content-Type": "application/json"}
+	testHTTP("POST", "/v0/DomainList", auth, "", http.StatusBadRequest, "protocol")
+	testHTTP("POST", "/v0/DomainList", auth, "{", http.StatusBadRequest, "protocol")
+	testHTTP("POST", "/v0/DomainList", auth, `{"Bogus": 1}`, http.StatusBadRequest, "protocol")
+	testHTTP("POST", "/v0/DomainList", auth, "{}", http.StatusOK, "")
+	// Request as form field, like the webapi.
+	testHTTP("POST", "/v0/DomainList", map[string]string{"Authorization": "Bearer " + token, "Content-Type": "application/x-www-form-urlencoded"}, "request={}", http.StatusOK, "")
+
+	// Client with bad token.
+	_, err = adminapi.Client{BaseURL: client.BaseURL, Token: "bogus"}.DomainList(ctxbg, adminapi.DomainListRequest{})
+	if err == nil {
+		t.Fatalf("call with bad token succeeded")
+	}
+	mox.LimitersInit()
+
+	// Domains.
+	domains, err := client.DomainList(ctxbg, adminapi.DomainListRequest{})
+	tcheckf(t, err, "domain list")
+	tcompare(t, domains.Domains, []adminapi.Domain{{Name: "mox.example", ASCII: "mox.example"}})
+
+	_, err = client.DomainAdd(ctxbg, adminapi.DomainAddRequest{Domain: "mox.example", Account: "mjl"})
+	terrcode(t, err, "user")
+	_, err = client.DomainAdd(ctxbg, adminapi.DomainAddRequest{Domain: "bad domain", Account: "mjl"})
+	terrcode(t, err, "user")
+	_, err = client.DomainAdd(ctxbg, adminapi.DomainAddRequest{Domain: "møx.example", Account: "other"})
+	terrcode(t, err, "user") // Localpart required for new account.
+	_, err = client.DomainAdd(ctxbg, adminapi.DomainAddRequest{Domain: "møx.example", Account: "mjl"})
+	tcheckf(t, err, "domain add")
+
+	domains, err = client.DomainList(ctxbg, adminapi.DomainListRequest{})
+	tcheckf(t, err, "domain list")
+	tcompare(t, len(domains.Domains), 2)
+	tcompare(t, domains.Domains[1], adminapi.Domain{Name: "møx.example", ASCII: "xn--mx-lka.example"})
+
+	// Accounts.
+	accounts, err := client.AccountList(ctxbg, adminapi.AccountListRequest{})
+	tcheckf(t, err, "account list")
+	tcompare(t, accounts.Accounts, []string{"mjl"})
+
+	_, err = client.AccountAdd(ctxbg, adminapi.AccountAddRequest{Account: "new", Address: "new@mox.example", Password: "short"})
+	terrcode(t, err, "user")
+	_, err = client.AccountAdd(ctxbg, adminapi.AccountAddRequest{Account: "new", Address: "new@bogus.example"})
+	terrcode(t, err, "user")
+	_, err = client.AccountAdd(ctxbg, adminapi.AccountAddRequest{Account: "new", Address: "new@mox.example", Password: "test1234"})
+	tcheckf(t, err, "account add")
+	_, err = client.AccountAdd(ctxbg, adminapi.AccountAddRequest{Account: "new", Address: "new2@mox.example"})
+	terrcode(t, err, "user")
+
+	acc, err := store.OpenEmailAuth(pkglog, "new@mox.example", "test1234")
+	tcheckf(t, err, "login with password set through api")
+	err = acc.Close()
+	tcheckf(t, err, "close account")
+
+	_, err = client.AccountGet(ctxbg, adminapi.AccountGetRequest{Account: "bogus"})
+	terrcode(t, err, "notFound")
+	accGet, err := client.AccountGet(ctxbg, adminapi.AccountGetRequest{Account: "new"})
+	tcheckf(t, err, "account get")
+	tcompare(t, accGet.Account, adminapi.Account{Name: "new", Domain: "mox.example", Addresses: []string{"new@mox.example"}})
+
+	fullName := "New User"
+	var quota int64 = 1024 * 1024
+	maxOutgoing := 10
+	_, err = client.AccountUpdate(ctxbg, adminapi.AccountUpdateRequest{Account: "bogus", FullName: &fullName})
+	terrcode(t, err, "notFound")
+	_, err = client.AccountUpdate(ctxbg, adminapi.AccountUpdateRequest{Account: "new", FullName: &fullName, QuotaMessageSize: &quota, MaxOutgoingMessagesPerDay: &maxOutgoing})
+	tcheckf(t, err, "account update")
+	accGet, err = client.AccountGet(ctxbg, adminapi.AccountGetRequest{Account: "new"})
+	tcheckf(t, err, "account get")
+	tcompare(t, accGet.Account.FullName, fullName)
+	tcompare(t, accGet.Account.QuotaMessageSize, quota)
+	tcompare(t, accGet.Account.MaxOutgoingMessagesPerDay, maxOutgoing)
+	tcompare(t, accGet.Account.MaxFirstTimeRecipientsPerDay, 0)
+
+	_, err = client.AccountPasswordSet(ctxbg, adminapi.AccountPasswordSetRequest{Account: "new", Password: "short"})
+	terrcode(t, err, "user")
+	_, err = client.AccountPasswordSet(ctxbg, adminapi.AccountPasswordSetRequest{Account: "bogus", Password: "test12345"})
+	terrcode(t, err, "notFound")
+	_, err = client.AccountPasswordSet(ctxbg, adminapi.AccountPasswordSetRequest{Account: "new", Password: "test12345"})
+	tcheckf(t, err, "set password")
+
+	// Addresses.
+	_, err = client.AddressAdd(ctxbg, adminapi.AddressAddRequest{Address: "new2@mox.example", Account: "bogus"})
+	terrcode(t, err, "notFound")
+	_, err = client.AddressAdd(ctxbg, adminapi.AddressAddRequest{Address: "mjl@mox.example", Account: "new"})
+	terrcode(t, err, "user")
+	_, err = client.AddressAdd(ctxbg, adminapi.AddressAddRequest{Address: "new2@mox.example", Account: "new"})
+	tcheckf(t, err, "address add")
+	accGet, err = client.AccountGet(ctxbg, adminapi.AccountGetRequest{Account: "new"})
+	tcheckf(t, err, "account get")
+	tcompare(t, accGet.Account.Addresses, []string{"new2@mox.example", "new@mox.example"})
+
+	// Aliases.
+	_, err = client.AliasList(ctxbg, adminapi.AliasListRequest{Domain: "bogus.example"})
+	terrcode(t, err, "notFound")
+	aliases, err := client.AliasList(ctxbg, adminapi.AliasListRequest{Domain: "mox.example"})
+	tcheckf(t, err, "alias list")
+	tcompare(t, aliases.Aliases, []adminapi.Alias{})
+
+	_, err = client.AliasAdd(ctxbg, adminapi.AliasAddRequest{Alias: adminapi.Alias{Address: "support@mox.example"}})
+	terrcode(t, err, "user")
+	_, err = client.AliasAdd(ctxbg, adminapi.AliasAddRequest{Alias: adminapi.Alias{Address: "support@mox.example", Members: []string{"bogus@mox.example"}}})
+	terrcode(t, err, "user")
+	_, err = client.AliasAdd(ctxbg, adminapi.AliasAddRequest{Alias: adminapi.Alias{Address: "support@mox.example", Members: []string{"mjl@mox.example"}, ListMembers: true}})
+	tcheckf(t, err, "alias add")
+
+	_, err = client.AliasGet(ctxbg, adminapi.AliasGetRequest{Address: "bogus@mox.example"})
+	terrcode(t, err, "notFound")
+	alias, err := client.AliasGet(ctxbg, adminapi.AliasGetRequest{Address: "support@mox.example"})
+	tcheckf(t, err, "alias get")
+	tcompare(t, alias.Alias, adminapi.Alias{Address: "support@mox.example", Members: []string{"mjl@mox.example"}, ListMembers: true})
+
+	postPublic := true
+	_, err = client.AliasUpdate(ctxbg, adminapi.AliasUpdateRequest{Address: "support@mox.example", PostPublic: &postPublic})
+	tcheckf(t, err, "alias update")
+
+	_, err = client.AliasMembersAdd(ctxbg, adminapi.AliasMembersAddRequest{Address: "support@mox.example", Members: []string{"new@mox.example"}})
+	tcheckf(t, err, "alias members add")
+	aliases, err = client.AliasList(ctxbg, adminapi.AliasListRequest{Domain: "mox.example"})
+	tcheckf(t, err, "alias list")
+	tcompare(t, aliases.Aliases, []adminapi.Alias{{Address: "support@mox.example", Members: []string{"mjl@mox.example", "new@mox.example"}, PostPublic: true, ListMembers: true}})
+
+	_, err = client.AliasMembersRemove(ctxbg, adminapi.AliasMembersRemoveRequest{Address: "support@mox.example", Members: []string{"bogus@mox.example"}})
+	terrcode(t, err, "user")
+	_, err = client.AliasMembersRemove(ctxbg, adminapi.AliasMembersRemoveRequest{Address: "support@mox.example", Members: []string{"new@mox.example"}})
+	tcheckf(t, err, "alias members remove")
+
+	_, err = client.AliasRemove(ctxbg, adminapi.AliasRemoveRequest{Address: "bogus@mox.example"})
+	terrcode(t, err, "notFound")
+	_, err = client.AliasRemove(ctxbg, adminapi.AliasRemoveRequest{Address: "support@mox.example"})
+	tcheckf(t, err, "alias remove")
+
+	// Clean up, leaving the configuration as we found it.
+	_, err = client.AddressRemove(ctxbg, adminapi.AddressRemoveRequest{Address: "new2@mox.example"})
+	tcheckf(t, err, "address remove")
+	_, err = client.AccountRemove(ctxbg, adminapi.AccountRemoveRequest{Account: "bogus"})
+	terrcode(t, err, "notFound")
+	_, err = client.AccountRemove(ctxbg, adminapi.AccountRemoveRequest{Account: "new"})
+	tcheckf(t, err, "account remove")
+	_, err = client.DomainRemove(ctxbg, adminapi.DomainRemoveRequest{Domain: "bogus.example"})
+	terrcode(t, err, "notFound")
+	_, err = client.DomainRemove(ctxbg, adminapi.DomainRemoveRequest{Domain: "møx.example"})
+	tcheckf(t, err, "domain remove")
+
+	// Queue.
+	queued, err := client.QueueList(ctxbg, adminapi.QueueListRequest{})
+	tcheckf(t, err, "queue list")
+	tcompare(t, queued.Messages, []adminapi.QueueMsg{})
+	kicked, err := client.QueueKick(ctxbg, adminapi.QueueKickRequest{Filter: adminapi.QueueFilter{Account: "mjl"}})
+	tcheckf(t, err, "queue kick")
+	tcompare(t, kicked.Affected, 0)
+	held, err := client.QueueHoldSet(ctxbg, adminapi.QueueHoldSetRequest{Filter: adminapi.QueueFilter{IDs: []int64{1}}, Hold: true})
+	tcheckf(t, err, "queue hold")
+	tcompare(t, held.Affected, 0)
+	dropped, err := client.QueueDrop(ctxbg, adminapi.QueueDropRequest{Filter: adminapi.QueueFilter{IDs: []int64{1}}})
+	tcheckf(t, err, "queue drop")
+	tcompare(t, dropped.Affected, 0)
+
+	// Declarative config.
+	domainsConf, err := os.ReadFile(mox.ConfigDynamicPath)
+	tcheckf(t, err, "read domains.conf")
+	desired := strings.Replace(string(domainsConf), "Accounts:\n", "Accounts:\n\tapplied:\n\t\tDomain: mox.example\n\t\tDestinations:\n\t\t\tapplied@mox.example: nil\n", 1)
+	applied, err := client.ConfigApply(ctxbg, adminapi.ConfigApplyRequest{Config: desired, DryRun: true})
+	tcheckf(t, err, "config apply dry run")
+	tcompare(t, applied, adminapi.ConfigApplyResult{Changes: []adminapi.ConfigChange{{Action: "add", Kind: "account", Name: "applied"}, {Action: "add", Kind: "address", Name: "applied@mox.example"}}})
+	_, err = client.AccountGet(ctxbg, adminapi.AccountGetRequest{Account: "applied"})
+	terrcode(t, err, "notFound")
+	applied, err = client.ConfigApply(ctxbg, adminapi.ConfigApplyRequest{Config: desired})
+	tcheckf(t, err, "config apply")
+	tcompare(t, applied.Applied, true)
+	tcompare(t, len(applied.Changes), 2)
+	applied, err = client.ConfigApply(ctxbg, adminapi.ConfigApplyRequest{Config: desired})
+	tcheckf(t, err, "config apply again")
+	tcompare(t, applied.Changes, []adminapi.ConfigChange{})
+	_, err = client.ConfigApply(ctxbg, adminapi.ConfigApplyRequest{Config: "Bogus: true\n"})
+	terrcode(t, err, "user")
+	applied, err = client.ConfigApply(ctxbg, adminapi.ConfigApplyRequest{Config: string(domainsConf)})
+	tcheckf(t, err, "config apply original")
+	tcompare(t, len(applied.Changes), 2)
+
+	// Log levels.
+	_, err = client.LogLevelSet(ctxbg, adminapi.LogLevelSetRequest{Pkg: "queue", Level: "debug"})
+	tcheckf(t, err, "log level set")
+	levels, err := client.LogLevels(ctxbg, adminapi.LogLevelsRequest{})
+	tcheckf(t, err, "log levels")
+	tcompare(t, levels.Levels["queue"], "debug")
+	_, err = client.LogLevelSet(ctxbg, adminapi.LogLevelSetRequest{Pkg: "queue"})
+	tcheckf(t, err, "log level remove")
+	levels, err = client.LogLevels(ctxbg, adminapi.LogLevelsRequest{})
+	tcheckf(t, err, "log levels")
+	if _, ok := levels.Levels["queue"]; ok {
+		t.Fatalf("log level for queue still present after removing")
+	}
+	_, err = client.LogLevelSet(ctxbg, adminapi.LogLevelSetRequest{Level: "bogus"})
+	terrcode(t, err, "user")
+
+	// Usage, per month, account and domain, and summed per account.
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	err = admindb.UsageAdd(ctxbg, now, "mjl", "mox.example", admindb.Usage{MessagesReceived: 1, BytesReceived: 100})
+	tcheckf(t, err, "add usage")
+	err = admindb.UsageAdd(ctxbg, now, "mjl", "other.example", admindb.Usage{MessagesSent: 1, BytesSent: 200})
+	tcheckf(t, err, "add usage")
+	usage, err := client.UsageList(ctxbg, adminapi.UsageListRequest{Start: "2026-10", End: "2026-10"})
+	tcheckf(t, err, "usage list")
+	tcompare(t, usage.Usage, []adminapi.Usage{
+		{Month: "2026-10", Account: "mjl", Domain: "mox.example", MessagesReceived: 1, BytesReceived: 100},
+		{Month: "2026-10", Account: "mjl", Domain: "other.example", MessagesSent: 1, BytesSent: 200},
+	})
+	usage, err = client.UsageList(ctxbg, adminapi.UsageListRequest{Group: "account", Format: "csv"})
+	tcheckf(t, err, "usage list csv")
+	tcompare(t, usage.CSV, "month,account,domain,messages_received,bytes_received,messages_sent,bytes_sent,stored_bytes\n2026-10,mjl,,1,100,1,200,0\n")
+	_, err = client.UsageList(ctxbg, adminapi.UsageListRequest{Start: "october"})
+	terrcode(t, err, "user")
+
+	// Roles limit the methods a token can call.
+	testRole := func(role admindb.Role, method string, expErrCode string) {
+		t.Helper()
+		rtoken, _, err := admindb.TokenAdd(ctxbg, "role-"+string(role), role)
+		tcheckf(t, err, "add token")
+		defer func() {
+			err := admindb.TokenRemove(ctxbg, "role-"+string(role))
+			tcheckf(t, err, "remove token")
+		}()
+		hdrs := map[string]string{"Authorization": "Bearer " + rtoken, "Content-Type": "application/json"}
+		expCode := http.StatusOK
+		if expErrCode != "" {
+			expCode = http.StatusBadRequest
+		}
+		body := "{}"
+		if method == "AccountAdd" {
+			body = `{"Account": "mjl", "Address": "mjl@mox.example"}` // Fails after permission check.
+		}
+		testHTTP("POST", "/v0/"+method, hdrs, body, expCode, expErrCode)
+	}
+	testRole(admindb.RoleReadonly, "DomainList", "")
+	testRole(admindb.RoleReadonly, "QueueList", "")
+	testRole(admindb.RoleReadonly, "QueueKick", "forbidden")
+	testRole(admindb.RoleReadonly, "AccountAdd", "forbidden")
+	testRole(admindb.RoleReadonly, "AuditList", "forbidden")
+	testRole(admindb.RoleQueue, "QueueKick", "")
+	testRole(admindb.RoleQueue, "AccountAdd", "forbidden")
+	testRole(admindb.RoleDomains, "AccountAdd", "user")
+	testRole(admindb.RoleDomains, "QueueKick", "forbidden")
+	testRole(admindb.RoleDomains, "AuditList", "forbidden")
+	testRole(admindb.RoleAdmin, "AuditList", "")
+	testRole(admindb.RoleDomains, "ConfigApply", "forbidden")
+	testRole(admindb.RoleReadonly, "LogLevels", "")
+	testRole(admindb.RoleQueue, "LogLevelSet", "forbidden")
+	testRole(admindb.RoleReadonly, "UsageList", "")
+
+	// Changes are in the audit log, most recent first, without passwords.
+	auditList, err := client.AuditList(ctxbg, adminapi.AuditListRequest{Source: "adminapi"})
+	tcheckf(t, err, "audit list")
+	entries := auditList.Entries
+	if len(entries) == 0 {
+		t.Fatalf("no audit log entries")
+	}
+	tcompare(t, entries[0].Action, "AccountAdd")
+	tcompare(t, entries[0].Actor, "role-domains")
+	if entries[0].Error == "" {
+		t.Fatalf("audit log entry for failed call without error")
+	}
+	var pwEntries int
+	for _, e := range entries {
+		if e.Action == "DomainList" || e.Action == "AccountGet" || e.Action == "QueueList" {
+			t.Fatalf("read-only method %s in audit log", e.Action)
+		}
+		if strings.Contains(e.Params, "test1234") || strings.Contains(e.Params, "test12345") {
+			t.Fatalf("password in audit log: %s", e.Params)
+		}
+		if e.Action == "AccountPasswordSet" && e.Error == "" {
+			pwEntries++
+			tcompare(t, e.Params, `{"Account":"new","Password":"***"}`)
+		}
+		if e.Action == "ConfigApply" && strings.Contains(e.Params, "Accounts:") {
+			t.Fatalf("config in audit log: %s", e.Params)
+		}
+	}
+	tcompare(t, pwEntries, 1)
+	auditList, err = client.AuditList(ctxbg, adminapi.AuditListRequest{Max: 1})
+	tcheckf(t, err, "audit list")
+	tcompare(t, len(auditList.Entries), 1)
+	auditList, err = client.AuditList(ctxbg, adminapi.AuditListRequest{Source: "ctl"})
+	tcheckf(t, err, "audit list")
+	tcompare(t, len(auditList.Entries), 0)
+
+	// Removed token can no longer be used.
+	err = admindb.TokenRemove(ctxbg, "test")
+	tcheckf(t, err, "remove token")
+	_, err = client.DomainList(ctxbg, adminapi.DomainListRequest{})
+	if err == nil {
+		t.Fatalf("call with removed token succeeded")
+	}
+	err = admindb.TokenRemove(ctxbg, "test")
+	if err == nil {
+		t.Fatalf("removing absent token succeeded")
+	}
+}
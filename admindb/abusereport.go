@@ -0,0 +1,106 @@
+package admindb
+
+import (
+	"context"
+	"time"
+
+	"github.com/mjl-/bstore"
+)
+
+// AbuseReport is a feedback report in the Abuse Reporting Format (ARF) received at
+// a role address like abuse@ or postmaster@, about a message sent by us. Reports
+// are correlated with the outgoing messages through the delivery history. Reports
+// are kept for 90 days.
+type AbuseReport struct {
+	ID           int64
+	Time         time.Time `bstore:"default now,index"`
+	Rcpt         string    // Role address the report was sent to.
+	ReporterFrom string    // Validated From address of the report message.
+	FeedbackType string    // E.g. "abuse", "fraud", "virus", "other", "not-spam".
+	UserAgent    string
+	SourceIP     string // IP of our server the original message was received from, according to the reporter.
+	ArrivalDate  time.Time
+	Incidents    int
+
+	// About the original message.
+	MessageID string // Canonical Message-ID: lower-case, without <>. Can be empty.
+	MailFrom  string // SMTP MAIL FROM.
+	RcptTo    string // SMTP RCPT TO addresses, separated by ", ". Often redacted by the reporter.
+	From      string // Message From header.
+	Subject   string
+
+	// Correlation with messages sent from the queue. Empty if no matching message
+	// was found.
+	Account    string   `bstore:"index"` // Sending account.
+	QueueIDs   []int64  // IDs of matching messages in the queue or delivery history.
+	Recipients []string // Recipients the report is about, from the report or the matching messages.
+	Suppressed []string // Recipients added to the suppression list of the account.
+
+	Handled bool // Set by admin, to keep track of which reports need attention.
+}
+
+// How long abuse reports are kept.
+const abuseReportKeep = 90 * 24 * time.Hour
+
+// AbuseReportAdd stores an abuse report, setting its ID and canonicalizing its
+// MessageID. Reports older than 90 days are removed.
+func AbuseReportAdd(ctx context.Context, r *AbuseReport) error {
+	db, err := database(ctx)
+	if err != nil {
+		return err
+	}
+	return db.Write(ctx, func(tx *bstore.Tx) error {
+		if _, err := bstore.QueryTx[AbuseReport](tx).FilterLess("Time", time.Now().Add(-abuseReportKeep)).Delete(); err != nil {
+			return err
+		}
+		r.ID = 0
+		r.MessageID = canonicalMessageID(r.MessageID)
+		return tx.Insert(r)
+	})
+}
+
+// AbuseReportList returns the most recent abuse reports, most recent first, at
+// most max if max > 0.
+func AbuseReportList(ctx context.Context, max int) ([]AbuseReport, error) {
+	db, err := database(ctx)
+	if err != nil {
+		return nil, err
+	}
+	q := bstore.QueryDB[AbuseReport](ctx, db)
+	q.SortDesc("Time", "ID")
+	if max > 0 {
+		q.Limit(max)
+	}
+	return q.List()
+}
+
+// AbuseReportSetHandled marks an abuse report as handled or not.
+func AbuseReportSetHandled(ctx context.Context, id int64, handled bool) error {
+	db, err := database(ctx)
+	if err != nil {
+		return err
+	}
+	return db.Write(ctx, func(tx *bstore.Tx) error {
+		r := AbuseReport{ID: id}
+		if err := tx.Get(&r); err == bstore.ErrAbsent {
+			return ErrNotFound
+		} else if err != nil {
+			return err
+		}
+		r.Handled = handled
+		return tx.Update(&r)
+	})
+}
+
+// AbuseComplaintsSince returns the number of spam complaints, i.e. abuse reports
+// with feedback type "abuse", about messages sent by account since a time.
+func AbuseComplaintsSince(ctx context.Context, account string, since time.Time) (int, error) {
+	db, err := database(ctx)
+	if err != nil {
+		return 0, err
+	}
+	q := bstore.QueryDB[AbuseReport](ctx, db)
+	q.FilterNonzero(AbuseReport{Account: account, FeedbackType: "abuse"})
+	q.FilterGreaterEqual("Time", since)
+	return q.Count()
+}
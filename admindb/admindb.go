@@ -0,0 +1,238 @@
+// Package admindb stores server-wide administrative data, such as tokens for
+// the admin API.
+package admindb
+
+import (
+	"context"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+)
+
+// Role of an API token, determining which admin API methods it can call.
+type Role string
+
+const (
+	RoleReadonly Role = "readonly" // Only methods that don't make changes.
+	RoleQueue    Role = "queue"    // Read-only methods, and managing the outgoing queue.
+	RoleDomains  Role = "domains"  // Read-only methods, and managing domains, accounts, addresses and aliases.
+	RoleAdmin    Role = "admin"    // All methods, including the audit log.
+)
+
+// Roles lists all valid roles, from least to most privileged.
+var Roles = []Role{RoleReadonly, RoleQueue, RoleDomains, RoleAdmin}
+
+// Allows returns whether a token with role r can call methods that require role
+// "required". Read-only methods are allowed for all roles, RoleAdmin is allowed
+// everything. An empty role, for tokens created before roles were introduced, is
+// treated as RoleAdmin.
+func (r Role) Allows(required Role) bool {
+	return r == RoleAdmin || r == "" || required == RoleReadonly || r == required
+}
+
+// APIToken is a bearer token for the admin API. Only a hash of the token is
+// stored, the token itself is only returned when it is created.
+type APIToken struct {
+	ID       int64
+	Created  time.Time `bstore:"default now"`
+	Name     string    `bstore:"nonzero,unique"` // For recognizing the token, e.g. name of the system using it.
+	Role     Role
+	Hash     string    `bstore:"nonzero,unique" json:"-"`
+	LastUsed time.Time // Updated at most once a minute.
+}
+
+// AuditEntry records an administrative action, e.g. a configuration change,
+// password reset or queue operation. Entries are only ever added, never removed.
+// References to an account are redacted when the account is purged, see
+// AuditRedact.
+type AuditEntry struct {
+	ID       int64
+	Time     time.Time `bstore:"default now,index"`
+	Source   string    // "webadmin", "adminapi" or "ctl".
+	Actor    string    // Name of API token for adminapi, login for webadmin. Empty for ctl.
+	RemoteIP string    // Empty for ctl.
+	Action   string    // Name of API method or ctl command, e.g. "AccountAdd".
+	Params   string    // Parameters as JSON (for ctl: command-line arguments), with secrets like passwords replaced by "***".
+	Error    string    // Empty if the action succeeded.
+}
+
+var (
+	ErrNotFound = errors.New("admindb: not found")
+	ErrExists   = errors.New("admindb: already exists")
+)
+
+var DBTypes = []any{APIToken{}, AuditEntry{}, AccountDeletion{}, SubmissionNetwork{}, SubmissionIncident{}, Quarantined{}, SpamtrapHit{}, MessageEvent{}, MTASTSTesting{}, ModerationHeld{}, DNSBLChange{}, Usage{}, AbuseReport{}, LineEndingSource{}, DomainSetup{}} // Types stored in DB.
+var DB *bstore.DB                                                                                                                                                                                                                                                      // Exported for backups.
+var mutex sync.Mutex
+
+func database(ctx context.Context) (rdb *bstore.DB, rerr error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if DB == nil {
+		p := mox.DataDirPath("admin.db")
+		os.MkdirAll(filepath.Dir(p), 0770)
+		db, err := bstore.Open(ctx, p, &bstore.Options{Timeout: 5 * time.Second, Perm: 0660}, DBTypes...)
+		if err != nil {
+			return nil, err
+		}
+		DB = db
+	}
+	return DB, nil
+}
+
+// Init opens the database.
+func Init() error {
+	_, err := database(mox.Shutdown)
+	return err
+}
+
+// Close closes the database.
+func Close() {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if DB != nil {
+		err := DB.Close()
+		mlog.New("admindb", nil).Check(err, "closing database")
+		DB = nil
+	}
+}
+
+func tokenHash(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// TokenAdd creates a new API token with a unique name and a role. The returned
+// token must be passed in an "Authorization: Bearer <token>" header.
+func TokenAdd(ctx context.Context, name string, role Role) (token string, t APIToken, rerr error) {
+	if name == "" {
+		return "", t, fmt.Errorf("%w: name must be non-empty", mox.ErrRequest)
+	} else if !slices.Contains(Roles, role) {
+		return "", t, fmt.Errorf("%w: unknown role %q", mox.ErrRequest, role)
+	}
+
+	db, err := database(ctx)
+	if err != nil {
+		return "", t, err
+	}
+
+	var buf [24]byte
+	if _, err := cryptorand.Read(buf[:]); err != nil {
+		return "", t, err
+	}
+	// Prefix makes tokens easy to recognize, e.g. by secret scanners.
+	token = "moxadmin_" + base64.RawURLEncoding.EncodeToString(buf[:])
+	t = APIToken{Name: name, Role: role, Hash: tokenHash(token)}
+	err = db.Write(ctx, func(tx *bstore.Tx) error {
+		exists, err := bstore.QueryTx[APIToken](tx).FilterNonzero(APIToken{Name: name}).Exists()
+		if err != nil {
+			return err
+		} else if exists {
+			return fmt.Errorf("%w: token with name %q", ErrExists, name)
+		}
+		return tx.Insert(&t)
+	})
+	if err != nil {
+		return "", APIToken{}, err
+	}
+	return token, t, nil
+}
+
+// TokenList returns all API tokens.
+func TokenList(ctx context.Context) ([]APIToken, error) {
+	db, err := database(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return bstore.QueryDB[APIToken](ctx, db).SortAsc("Name").List()
+}
+
+// TokenRemove removes the API token by name.
+func TokenRemove(ctx context.Context, name string) error {
+	db, err := database(ctx)
+	if err != nil {
+		return err
+	}
+	n, err := bstore.QueryDB[APIToken](ctx, db).FilterNonzero(APIToken{Name: name}).Delete()
+	if err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("%w: token with name %q", ErrNotFound, name)
+	}
+	return nil
+}
+
+// TokenVerify looks up an API token by its secret value, returning ErrNotFound
+// for unknown tokens.
+func TokenVerify(ctx context.Context, token string) (APIToken, error) {
+	db, err := database(ctx)
+	if err != nil {
+		return APIToken{}, err
+	}
+	t, err := bstore.QueryDB[APIToken](ctx, db).FilterNonzero(APIToken{Hash: tokenHash(token)}).Get()
+	if err == bstore.ErrAbsent {
+		return APIToken{}, ErrNotFound
+	} else if err != nil {
+		return APIToken{}, err
+	}
+	if now := time.Now(); now.Sub(t.LastUsed) > time.Minute {
+		t.LastUsed = now
+		err := db.Update(ctx, &t)
+		mlog.New("admindb", nil).Check(err, "updating last use of api token")
+	}
+	return t, nil
+}
+
+// AuditAdd adds an entry to the audit log.
+func AuditAdd(ctx context.Context, e *AuditEntry) error {
+	db, err := database(ctx)
+	if err != nil {
+		return err
+	}
+	e.ID = 0
+	return db.Insert(ctx, e)
+}
+
+// AuditFilter selects entries from the audit log. Zero values don't filter.
+type AuditFilter struct {
+	Start  time.Time // Inclusive.
+	End    time.Time // Exclusive.
+	Source string
+	Max    int
+}
+
+// AuditList returns entries from the audit log, most recent first.
+func AuditList(ctx context.Context, f AuditFilter) ([]AuditEntry, error) {
+	db, err := database(ctx)
+	if err != nil {
+		return nil, err
+	}
+	q := bstore.QueryDB[AuditEntry](ctx, db)
+	if !f.Start.IsZero() {
+		q.FilterGreaterEqual("Time", f.Start)
+	}
+	if !f.End.IsZero() {
+		q.FilterLess("Time", f.End)
+	}
+	if f.Source != "" {
+		q.FilterNonzero(AuditEntry{Source: f.Source})
+	}
+	if f.Max > 0 {
+		q.Limit(f.Max)
+	}
+	q.SortDesc("Time", "ID")
+	return q.List()
+}
@@ -0,0 +1,125 @@
+package admindb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mjl-/bstore"
+)
+
+// AccountDeletion is a pending deletion of an account. Logins to the account are
+// disabled while the deletion is pending. After the grace period, the account and
+// all its data are purged.
+type AccountDeletion struct {
+	ID          int64
+	Account     string    `bstore:"nonzero,unique"`
+	Requested   time.Time `bstore:"default now"`
+	PurgeAfter  time.Time `bstore:"index"`
+	RequestedBy string    // E.g. "account", "admin" or "ctl".
+	Addresses   []string  // Addresses of the account at request time, for redacting references when purging.
+}
+
+// DeletionAdd adds a pending account deletion. If a deletion for the account is
+// already pending, an error wrapping ErrExists is returned.
+func DeletionAdd(ctx context.Context, d *AccountDeletion) error {
+	db, err := database(ctx)
+	if err != nil {
+		return err
+	}
+	return db.Write(ctx, func(tx *bstore.Tx) error {
+		exists, err := bstore.QueryTx[AccountDeletion](tx).FilterNonzero(AccountDeletion{Account: d.Account}).Exists()
+		if err != nil {
+			return err
+		} else if exists {
+			return fmt.Errorf("%w: deletion already pending for account %q", ErrExists, d.Account)
+		}
+		d.ID = 0
+		return tx.Insert(d)
+	})
+}
+
+// DeletionGet returns the pending deletion for an account, or ErrNotFound.
+func DeletionGet(ctx context.Context, account string) (AccountDeletion, error) {
+	db, err := database(ctx)
+	if err != nil {
+		return AccountDeletion{}, err
+	}
+	d, err := bstore.QueryDB[AccountDeletion](ctx, db).FilterNonzero(AccountDeletion{Account: account}).Get()
+	if err == bstore.ErrAbsent {
+		return AccountDeletion{}, ErrNotFound
+	}
+	return d, err
+}
+
+// DeletionList returns all pending account deletions, first to be purged first.
+func DeletionList(ctx context.Context) ([]AccountDeletion, error) {
+	db, err := database(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return bstore.QueryDB[AccountDeletion](ctx, db).SortAsc("PurgeAfter", "ID").List()
+}
+
+// DeletionRemove removes the pending deletion for an account, returning
+// ErrNotFound if there is none.
+func DeletionRemove(ctx context.Context, account string) error {
+	db, err := database(ctx)
+	if err != nil {
+		return err
+	}
+	n, err := bstore.QueryDB[AccountDeletion](ctx, db).FilterNonzero(AccountDeletion{Account: account}).Delete()
+	if err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("%w: no deletion pending for account %q", ErrNotFound, account)
+	}
+	return nil
+}
+
+// AuditRedact replaces the account name, as JSON string, and its addresses in the
+// parameters and actors of audit log entries with "***". The entries themselves
+// are kept. Returns the number of changed entries.
+func AuditRedact(ctx context.Context, account string, addresses []string) (int, error) {
+	db, err := database(ctx)
+	if err != nil {
+		return 0, err
+	}
+	name, err := json.Marshal(account)
+	if err != nil {
+		return 0, err
+	}
+	redact := func(s string) string {
+		s = strings.ReplaceAll(s, string(name), `"***"`)
+		for _, addr := range addresses {
+			s = strings.ReplaceAll(s, addr, "***")
+		}
+		return s
+	}
+	var n int
+	err = db.Write(ctx, func(tx *bstore.Tx) error {
+		entries, err := bstore.QueryTx[AuditEntry](tx).List()
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			params, actor := redact(e.Params), e.Actor
+			if actor == account || actor != "" && redact(actor) != actor {
+				actor = "***"
+			}
+			if params == e.Params && actor == e.Actor {
+				continue
+			}
+			e.Params = params
+			e.Actor = actor
+			if err := tx.Update(&e); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
@@ -0,0 +1,74 @@
+package admindb
+
+import (
+	"context"
+	"time"
+
+	"github.com/mjl-/bstore"
+)
+
+// DNSBLChange records a change of the listing status of one of our IPs in a DNS
+// block list, as seen by the DNSBL monitor. The first check of an IP in a zone is
+// recorded too, so the latest change for a zone and IP is its current status.
+type DNSBLChange struct {
+	ID          int64
+	Time        time.Time `bstore:"default now,index"`
+	Zone        string    `bstore:"nonzero,index"` // DNSBL zone, e.g. sbl.spamhaus.org.
+	IP          string    `bstore:"nonzero"`
+	Listed      bool
+	Explanation string // From the TXT record of the block list, if listed.
+}
+
+// DNSBLRecord records the listing status of an IP in a zone if it differs from
+// the latest recorded status, returning whether a change was recorded.
+func DNSBLRecord(ctx context.Context, zone, ip string, listed bool, explanation string, now time.Time) (changed bool, rerr error) {
+	db, err := database(ctx)
+	if err != nil {
+		return false, err
+	}
+	err = db.Write(ctx, func(tx *bstore.Tx) error {
+		q := bstore.QueryTx[DNSBLChange](tx)
+		q.FilterNonzero(DNSBLChange{Zone: zone, IP: ip})
+		q.SortDesc("Time", "ID")
+		c, err := q.Limit(1).Get()
+		if err == nil && c.Listed == listed {
+			return nil
+		} else if err != nil && err != bstore.ErrAbsent {
+			return err
+		}
+		changed = true
+		return tx.Insert(&DNSBLChange{Time: now, Zone: zone, IP: ip, Listed: listed, Explanation: explanation})
+	})
+	return changed, err
+}
+
+// DNSBLStatus returns the latest recorded status of an IP in a zone. If the IP
+// was never checked in the zone, ErrNotFound is returned.
+func DNSBLStatus(ctx context.Context, zone, ip string) (DNSBLChange, error) {
+	db, err := database(ctx)
+	if err != nil {
+		return DNSBLChange{}, err
+	}
+	q := bstore.QueryDB[DNSBLChange](ctx, db)
+	q.FilterNonzero(DNSBLChange{Zone: zone, IP: ip})
+	q.SortDesc("Time", "ID")
+	c, err := q.Limit(1).Get()
+	if err == bstore.ErrAbsent {
+		return c, ErrNotFound
+	}
+	return c, err
+}
+
+// DNSBLChangeList returns the most recent changes, at most max if max > 0.
+func DNSBLChangeList(ctx context.Context, max int) ([]DNSBLChange, error) {
+	db, err := database(ctx)
+	if err != nil {
+		return nil, err
+	}
+	q := bstore.QueryDB[DNSBLChange](ctx, db)
+	q.SortDesc("Time", "ID")
+	if max > 0 {
+		q.Limit(max)
+	}
+	return q.List()
+}
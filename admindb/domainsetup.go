@@ -0,0 +1,84 @@
+package admindb
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mjl-/bstore"
+)
+
+// DomainSetup is a domain added with the add domain wizard of the admin web
+// interface, for which the DNS records have not yet been verified. Outgoing
+// messages for the domain are not DKIM-signed, and its MTA-STS policy stays in
+// mode testing, until the setup is activated.
+type DomainSetup struct {
+	ID            int64
+	Created       time.Time `bstore:"default now"`
+	Domain        string    `bstore:"nonzero,unique"` // Unicode.
+	Sign          []string  // DKIM selectors to sign with once activated.
+	MTASTSEnforce bool      // Whether to change the MTA-STS policy to mode enforce once activated.
+	LastCheck     time.Time // Of DNS records. Zero if not yet checked.
+}
+
+// DomainSetupAdd adds a pending setup for a domain. If a setup for the domain is
+// already pending, an error wrapping ErrExists is returned.
+func DomainSetupAdd(ctx context.Context, s *DomainSetup) error {
+	db, err := database(ctx)
+	if err != nil {
+		return err
+	}
+	return db.Write(ctx, func(tx *bstore.Tx) error {
+		exists, err := bstore.QueryTx[DomainSetup](tx).FilterNonzero(DomainSetup{Domain: s.Domain}).Exists()
+		if err != nil {
+			return err
+		} else if exists {
+			return fmt.Errorf("%w: setup already pending for domain %q", ErrExists, s.Domain)
+		}
+		s.ID = 0
+		return tx.Insert(s)
+	})
+}
+
+// DomainSetupGet returns the pending setup for a domain, or ErrNotFound.
+func DomainSetupGet(ctx context.Context, domain string) (DomainSetup, error) {
+	db, err := database(ctx)
+	if err != nil {
+		return DomainSetup{}, err
+	}
+	s, err := bstore.QueryDB[DomainSetup](ctx, db).FilterNonzero(DomainSetup{Domain: domain}).Get()
+	if err == bstore.ErrAbsent {
+		return DomainSetup{}, ErrNotFound
+	}
+	return s, err
+}
+
+// DomainSetupList returns all pending domain setups, oldest first.
+func DomainSetupList(ctx context.Context) ([]DomainSetup, error) {
+	db, err := database(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return bstore.QueryDB[DomainSetup](ctx, db).SortAsc("Created", "ID").List()
+}
+
+// DomainSetupChecked records the time the DNS records of a pending setup were
+// last checked.
+func DomainSetupChecked(ctx context.Context, domain string, t time.Time) error {
+	db, err := database(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = bstore.QueryDB[DomainSetup](ctx, db).FilterNonzero(DomainSetup{Domain: domain}).UpdateNonzero(DomainSetup{LastCheck: t})
+	return err
+}
+
+// DomainSetupRemove removes the pending setup for a domain, if any.
+func DomainSetupRemove(ctx context.Context, domain string) error {
+	db, err := database(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = bstore.QueryDB[DomainSetup](ctx, db).FilterNonzero(DomainSetup{Domain: domain}).Delete()
+	return err
+}
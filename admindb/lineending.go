@@ -0,0 +1,86 @@
+package admindb
+
+import (
+	"context"
+	"time"
+
+	"github.com/mjl-/bstore"
+)
+
+// LineEndingSource counts incoming messages with line ending anomalies in the SMTP
+// message data, such as bare carriage returns or newlines, per remote IP. Used to
+// see which senders would be affected when rejecting anomalies. Sources not seen
+// for 30 days are removed.
+type LineEndingSource struct {
+	ID       int64
+	RemoteIP string `bstore:"unique,nonzero"`
+
+	// Of the most recent message.
+	EHLO           string
+	MailFromDomain string // Unicode. Empty for the null reverse path.
+	Submission     bool   // Whether submitted by an authenticated account.
+
+	Messages   int64 // Number of messages with any anomaly.
+	BareCR     int64 // Messages with bare carriage returns.
+	BareLF     int64 // Messages with bare newlines.
+	BadDataEnd int64 // Messages with a dot on a line with other line endings than CRLF.
+	Rejected   int64 // Messages rejected due to an anomaly.
+
+	First time.Time `bstore:"default now"`
+	Last  time.Time `bstore:"default now,index"`
+}
+
+// How long line ending sources are kept after their last message.
+const lineEndingSourceKeep = 30 * 24 * time.Hour
+
+// LineEndingSourceAdd adds the counters of delta to the source with the remote IP
+// of delta, updating the fields of the most recent message.
+func LineEndingSourceAdd(ctx context.Context, delta LineEndingSource) error {
+	db, err := database(ctx)
+	if err != nil {
+		return err
+	}
+	return db.Write(ctx, func(tx *bstore.Tx) error {
+		now := time.Now()
+		if _, err := bstore.QueryTx[LineEndingSource](tx).FilterLess("Last", now.Add(-lineEndingSourceKeep)).Delete(); err != nil {
+			return err
+		}
+
+		q := bstore.QueryTx[LineEndingSource](tx)
+		q.FilterNonzero(LineEndingSource{RemoteIP: delta.RemoteIP})
+		s, err := q.Get()
+		if err == bstore.ErrAbsent {
+			s = LineEndingSource{RemoteIP: delta.RemoteIP, First: now}
+		} else if err != nil {
+			return err
+		}
+		s.EHLO = delta.EHLO
+		s.MailFromDomain = delta.MailFromDomain
+		s.Submission = delta.Submission
+		s.Messages += delta.Messages
+		s.BareCR += delta.BareCR
+		s.BareLF += delta.BareLF
+		s.BadDataEnd += delta.BadDataEnd
+		s.Rejected += delta.Rejected
+		s.Last = now
+		if s.ID == 0 {
+			return tx.Insert(&s)
+		}
+		return tx.Update(&s)
+	})
+}
+
+// LineEndingSourceList returns the sources with line ending anomalies, most
+// recent first, at most max if max > 0.
+func LineEndingSourceList(ctx context.Context, max int) ([]LineEndingSource, error) {
+	db, err := database(ctx)
+	if err != nil {
+		return nil, err
+	}
+	q := bstore.QueryDB[LineEndingSource](ctx, db)
+	q.SortDesc("Last", "ID")
+	if max > 0 {
+		q.Limit(max)
+	}
+	return q.List()
+}
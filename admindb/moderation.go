@@ -0,0 +1,74 @@
+package admindb
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mjl-/bstore"
+)
+
+// ModerationHeld is an incoming message for a moderated alias, from a sender that
+// is not a member, held for approval by the owner of the alias. The message file
+// is stored separately, see package moderation.
+type ModerationHeld struct {
+	ID        int64
+	Token     string    `bstore:"nonzero,unique"` // Secret for the approval link.
+	Received  time.Time `bstore:"default now"`
+	Expires   time.Time `bstore:"index"`
+	Alias     string    `bstore:"nonzero,index"` // Alias address, unicode.
+	RemoteIP  string
+	MailFrom  string // SMTP MAIL FROM, empty for the null sender.
+	MsgFrom   string // Address in message From header.
+	Subject   string
+	MessageID string
+	Size      int64 // Including prefix with headers added during delivery.
+	Has8bit   bool
+	SMTPUTF8  bool
+}
+
+// ModerationAdd adds a held message, setting its ID.
+func ModerationAdd(ctx context.Context, h *ModerationHeld) error {
+	db, err := database(ctx)
+	if err != nil {
+		return err
+	}
+	h.ID = 0
+	return db.Insert(ctx, h)
+}
+
+// ModerationGet returns a held message by its token, or ErrNotFound.
+func ModerationGet(ctx context.Context, token string) (ModerationHeld, error) {
+	db, err := database(ctx)
+	if err != nil {
+		return ModerationHeld{}, err
+	}
+	h, err := bstore.QueryDB[ModerationHeld](ctx, db).FilterNonzero(ModerationHeld{Token: token}).Get()
+	if err == bstore.ErrAbsent {
+		return ModerationHeld{}, fmt.Errorf("%w: no held message for token", ErrNotFound)
+	}
+	return h, err
+}
+
+// ModerationExpired returns held messages that expired before now.
+func ModerationExpired(ctx context.Context, now time.Time) ([]ModerationHeld, error) {
+	db, err := database(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return bstore.QueryDB[ModerationHeld](ctx, db).FilterLess("Expires", now).List()
+}
+
+// ModerationRemove removes a held message, returning ErrNotFound if it does not
+// exist.
+func ModerationRemove(ctx context.Context, id int64) error {
+	db, err := database(ctx)
+	if err != nil {
+		return err
+	}
+	err = db.Delete(ctx, &ModerationHeld{ID: id})
+	if err == bstore.ErrAbsent {
+		return fmt.Errorf("%w: no held message with id %d", ErrNotFound, id)
+	}
+	return err
+}
@@ -0,0 +1,188 @@
+package admindb
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/maps"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/mlog"
+)
+
+// EventKind is the kind of step in the handling of a message.
+type EventKind string
+
+const (
+	EventReceived    EventKind = "received"    // Message received over SMTP, incoming or submitted.
+	EventRuleset     EventKind = "ruleset"     // Ruleset of destination matched, selecting the mailbox.
+	EventJunk        EventKind = "junk"        // Result of junk filter classification.
+	EventDelivered   EventKind = "delivered"   // Delivered to a mailbox of an account, or to a remote server from the queue.
+	EventRejected    EventKind = "rejected"    // Incoming message rejected for a recipient.
+	EventQuarantined EventKind = "quarantined" // Incoming message held in quarantine instead of being rejected.
+	EventDiscarded   EventKind = "discarded"   // Incoming message discarded, e.g. by a filter rule or for a spamtrap.
+	EventQueued      EventKind = "queued"      // Added to the queue for delivery to a recipient.
+	EventAttempt     EventKind = "attempt"     // Delivery attempt from the queue with a temporary failure, will be retried.
+	EventFailed      EventKind = "failed"      // Delivery from the queue failed permanently, or message was dropped from the queue.
+)
+
+// MessageEvent is a step in the handling of a message, e.g. its receipt over
+// SMTP, the outcome of junk analysis, or an attempt at delivering it from the
+// queue. The events for a message form its delivery history, see
+// MessageEventList. Events are removed after 30 days.
+type MessageEvent struct {
+	ID        int64
+	Time      time.Time `bstore:"default now,index"`
+	MessageID string    `bstore:"index"` // Canonical Message-ID header: lower-case, without <>. Can be empty.
+	QueueID   int64     `bstore:"index"` // ID of message in queue, for outgoing messages.
+	Cid       int64     // Connection ID, as in the "cid" attribute in logging, in hex.
+	Kind      EventKind
+	Account   string // Receiving account for incoming messages, sending account for outgoing messages.
+	Recipient string // If event is for a single recipient.
+	Remote    string // Remote IP for received messages, remote host for deliveries from the queue.
+	Result    string // E.g. the reason for accepting or rejecting, or the SMTP code from the remote.
+	Detail    string // E.g. junk probability, or error or response from remote server.
+}
+
+const messageEventKeep = 30 * 24 * time.Hour
+
+var messageEventCleanup struct {
+	sync.Mutex
+	last time.Time
+}
+
+// MessageEventAdd records an event in the delivery history of a message. The
+// connection ID is taken from ctx if not set. The history is informational, so
+// errors are logged and not returned. Events older than 30 days are removed
+// periodically.
+func MessageEventAdd(ctx context.Context, log mlog.Log, e MessageEvent) {
+	e.ID = 0
+	e.MessageID = canonicalMessageID(e.MessageID)
+	if e.Cid == 0 {
+		e.Cid, _ = ctx.Value(mlog.CidKey).(int64)
+	}
+	db, err := database(ctx)
+	if err == nil {
+		err = db.Insert(ctx, &e)
+	}
+	if err != nil {
+		log.Errorx("adding message event", err, slog.String("kind", string(e.Kind)), slog.String("messageid", e.MessageID))
+		return
+	}
+
+	messageEventCleanup.Lock()
+	if time.Since(messageEventCleanup.last) < time.Hour {
+		messageEventCleanup.Unlock()
+		return
+	}
+	messageEventCleanup.last = time.Now()
+	messageEventCleanup.Unlock()
+
+	n, err := bstore.QueryDB[MessageEvent](ctx, db).FilterLess("Time", time.Now().Add(-messageEventKeep)).Delete()
+	if err != nil {
+		log.Errorx("removing old message events", err)
+	} else if n > 0 {
+		log.Debug("removed old message events", slog.Int("count", n))
+	}
+}
+
+// MessageEventRecent returns the most recent events of the given kinds, most
+// recent first, at most max if max > 0.
+func MessageEventRecent(ctx context.Context, kinds []EventKind, max int) ([]MessageEvent, error) {
+	db, err := database(ctx)
+	if err != nil {
+		return nil, err
+	}
+	q := bstore.QueryDB[MessageEvent](ctx, db)
+	q.FilterFn(func(e MessageEvent) bool { return slices.Contains(kinds, e.Kind) })
+	q.SortDesc("Time", "ID")
+	if max > 0 {
+		q.Limit(max)
+	}
+	return q.List()
+}
+
+// MessageEventList returns the delivery history of a message, oldest first. The
+// id is either a Message-ID, with or without <>, or the numeric ID of a message
+// in the queue. Events for the same Message-ID and for the same queued messages
+// are included, so the history of a submitted message includes its deliveries to
+// each recipient.
+func MessageEventList(ctx context.Context, id string) ([]MessageEvent, error) {
+	db, err := database(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[int64]bool{}
+	var l []MessageEvent
+	messageIDs := map[string]bool{}
+	queueIDs := map[int64]bool{}
+	add := func(q *bstore.Query[MessageEvent]) error {
+		return q.ForEach(func(e MessageEvent) error {
+			if seen[e.ID] {
+				return nil
+			}
+			seen[e.ID] = true
+			l = append(l, e)
+			if e.MessageID != "" {
+				messageIDs[e.MessageID] = true
+			}
+			if e.QueueID != 0 {
+				queueIDs[e.QueueID] = true
+			}
+			return nil
+		})
+	}
+
+	if qid, err := strconv.ParseInt(id, 10, 64); err == nil && qid > 0 {
+		err := add(bstore.QueryDB[MessageEvent](ctx, db).FilterNonzero(MessageEvent{QueueID: qid}))
+		if err != nil {
+			return nil, err
+		}
+	} else if mid := canonicalMessageID(id); mid != "" {
+		messageIDs[mid] = true
+	}
+	// Message-IDs first, they can add queue IDs of deliveries of the same message.
+	for _, mid := range maps.Keys(messageIDs) {
+		if err := add(bstore.QueryDB[MessageEvent](ctx, db).FilterNonzero(MessageEvent{MessageID: mid})); err != nil {
+			return nil, err
+		}
+	}
+	for _, qid := range maps.Keys(queueIDs) {
+		if err := add(bstore.QueryDB[MessageEvent](ctx, db).FilterNonzero(MessageEvent{QueueID: qid})); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(l, func(i, j int) bool {
+		if !l[i].Time.Equal(l[j].Time) {
+			return l[i].Time.Before(l[j].Time)
+		}
+		return l[i].ID < l[j].ID
+	})
+	return l, nil
+}
+
+// canonicalMessageID returns the Message-ID as stored with messages in accounts:
+// lower-case, without <>.
+func canonicalMessageID(s string) string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return ""
+	}
+	if !strings.HasPrefix(s, "<") {
+		s = "<" + s + ">"
+	}
+	if mid, _, err := message.MessageIDCanonical(s); err == nil {
+		return mid
+	}
+	return strings.ToLower(strings.TrimSuffix(strings.TrimPrefix(s, "<"), ">"))
+}
@@ -0,0 +1,130 @@
+package admindb
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+)
+
+var ctxbg = context.Background()
+
+func tcheck(t *testing.T, err error, msg string) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %v", msg, err)
+	}
+}
+
+func TestMessageEvents(t *testing.T) {
+	log := mlog.New("admindb", nil)
+	os.RemoveAll("../testdata/admindb/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/admindb/mox.conf")
+	mox.ConfigDynamicPath = filepath.FromSlash("../testdata/admindb/domains.conf")
+	mox.MustLoadConfig(true, false)
+	mox.Shutdown = ctxbg
+	err := Init()
+	tcheck(t, err, "init")
+	defer Close()
+
+	// Events of an incoming message, and its forwarding through the queue to two
+	// recipients, and an unrelated message.
+	now := time.Now()
+	add := func(e MessageEvent, age time.Duration) {
+		t.Helper()
+		e.Time = now.Add(-age)
+		MessageEventAdd(ctxbg, log, e)
+	}
+	cidctx := context.WithValue(ctxbg, mlog.CidKey, int64(0x1234))
+	MessageEventAdd(cidctx, log, MessageEvent{Time: now.Add(-10 * time.Minute), MessageID: "<Test@Remote.Example>", Kind: EventReceived, Remote: "198.51.100.1"})
+	add(MessageEvent{MessageID: "test@remote.example", Kind: EventJunk, Account: "mjl", Result: "ham"}, 9*time.Minute)
+	add(MessageEvent{MessageID: "test@remote.example", Kind: EventQueued, QueueID: 10, Recipient: "a@other.example"}, 8*time.Minute)
+	add(MessageEvent{MessageID: "test@remote.example", Kind: EventQueued, QueueID: 11, Recipient: "b@other.example"}, 8*time.Minute)
+	// Queue events without Message-ID are found through the queue ID.
+	add(MessageEvent{Kind: EventAttempt, QueueID: 10, Remote: "mx.other.example", Result: "451"}, 7*time.Minute)
+	add(MessageEvent{Kind: EventDelivered, QueueID: 10, Remote: "mx.other.example"}, 5*time.Minute)
+	add(MessageEvent{Kind: EventFailed, QueueID: 11, Remote: "mx.other.example", Result: "550"}, 6*time.Minute)
+	add(MessageEvent{MessageID: "<other@remote.example>", Kind: EventRejected, Recipient: "mjl@mox.example"}, time.Minute)
+
+	type event struct {
+		Kind    EventKind
+		QueueID int64
+	}
+	test := func(id string, exp []event) []MessageEvent {
+		t.Helper()
+		l, err := MessageEventList(ctxbg, id)
+		tcheck(t, err, "list message events")
+		var got []event
+		for _, e := range l {
+			got = append(got, event{e.Kind, e.QueueID})
+		}
+		if !reflect.DeepEqual(got, exp) {
+			t.Fatalf("events for %q:\n%v\nexpected:\n%v", id, got, exp)
+		}
+		return l
+	}
+
+	all := []event{
+		{EventReceived, 0},
+		{EventJunk, 0},
+		{EventQueued, 10},
+		{EventQueued, 11},
+		{EventAttempt, 10},
+		{EventFailed, 11},
+		{EventDelivered, 10},
+	}
+	// By Message-ID, with or without <>, in any case, and by queue ID.
+	l := test("<test@remote.example>", all)
+	test("TEST@remote.example", all)
+	test(" <test@REMOTE.example> ", all)
+	test("10", all)
+	test("11", all)
+
+	// Message-ID is stored canonical, cid taken from context.
+	if l[0].MessageID != "test@remote.example" || l[0].Cid != 0x1234 {
+		t.Fatalf("got message-id %q, cid %x, expected test@remote.example and 1234", l[0].MessageID, l[0].Cid)
+	}
+
+	test("other@remote.example", []event{{EventRejected, 0}})
+	test("unknown@remote.example", nil)
+	test("12", nil)
+	test("", nil)
+
+	// Recent events, most recent first, of selected kinds.
+	recent, err := MessageEventRecent(ctxbg, []EventKind{EventDelivered, EventFailed, EventRejected}, 0)
+	tcheck(t, err, "recent events")
+	var kinds []EventKind
+	for _, e := range recent {
+		kinds = append(kinds, e.Kind)
+	}
+	if !reflect.DeepEqual(kinds, []EventKind{EventRejected, EventDelivered, EventFailed}) {
+		t.Fatalf("got recent kinds %v", kinds)
+	}
+	recent, err = MessageEventRecent(ctxbg, []EventKind{EventDelivered, EventFailed, EventRejected}, 2)
+	tcheck(t, err, "recent events")
+	if len(recent) != 2 || recent[0].Kind != EventRejected {
+		t.Fatalf("got %d recent events, expected 2 starting with rejected", len(recent))
+	}
+
+	// Old events are removed when adding an event, at most once per hour.
+	add(MessageEvent{MessageID: "old@remote.example", Kind: EventReceived}, messageEventKeep+time.Hour)
+	test("old@remote.example", []event{{EventReceived, 0}})
+	messageEventCleanup.Lock()
+	messageEventCleanup.last = time.Time{}
+	messageEventCleanup.Unlock()
+	add(MessageEvent{MessageID: "new@remote.example", Kind: EventReceived}, 0)
+	test("old@remote.example", nil)
+	test("new@remote.example", []event{{EventReceived, 0}})
+	n, err := bstore.QueryDB[MessageEvent](ctxbg, DB).Count()
+	tcheck(t, err, "count events")
+	if n != len(all)+2 {
+		t.Fatalf("got %d events, expected %d", n, len(all)+2)
+	}
+}
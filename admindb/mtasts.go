@@ -0,0 +1,54 @@
+package admindb
+
+import (
+	"context"
+	"time"
+
+	"github.com/mjl-/bstore"
+)
+
+// MTASTSTesting records since when the MTA-STS policy of a domain has been in mode
+// testing with its current policy ID. Used for automatically promoting the policy
+// to mode enforce.
+type MTASTSTesting struct {
+	ID       int64
+	Domain   string `bstore:"nonzero,unique"` // Unicode.
+	PolicyID string
+	Start    time.Time
+}
+
+// MTASTSTestingEnsure returns the testing record for a domain. If there is no
+// record yet, or the record is for another policy ID, a record starting at now is
+// stored.
+func MTASTSTestingEnsure(ctx context.Context, domain, policyID string, now time.Time) (t MTASTSTesting, rerr error) {
+	db, err := database(ctx)
+	if err != nil {
+		return t, err
+	}
+	err = db.Write(ctx, func(tx *bstore.Tx) error {
+		var err error
+		t, err = bstore.QueryTx[MTASTSTesting](tx).FilterNonzero(MTASTSTesting{Domain: domain}).Get()
+		if err == bstore.ErrAbsent {
+			t = MTASTSTesting{Domain: domain, PolicyID: policyID, Start: now}
+			return tx.Insert(&t)
+		} else if err != nil {
+			return err
+		} else if t.PolicyID != policyID {
+			t.PolicyID = policyID
+			t.Start = now
+			return tx.Update(&t)
+		}
+		return nil
+	})
+	return t, err
+}
+
+// MTASTSTestingRemove removes the testing record for a domain, if any.
+func MTASTSTestingRemove(ctx context.Context, domain string) error {
+	db, err := database(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = bstore.QueryDB[MTASTSTesting](ctx, db).FilterNonzero(MTASTSTesting{Domain: domain}).Delete()
+	return err
+}
@@ -0,0 +1,102 @@
+package admindb
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mjl-/bstore"
+)
+
+// Quarantined is an incoming message held in quarantine instead of being
+// rejected. The message file is stored separately, see package quarantine.
+type Quarantined struct {
+	ID        int64
+	Received  time.Time `bstore:"default now,index"`
+	Expires   time.Time `bstore:"index"`
+	Account   string    `bstore:"nonzero,index"`
+	Mailbox   string    // Mailbox to deliver to when released.
+	Reason    string    // Reason the message would have been rejected, e.g. "hash-blocklisted".
+	RemoteIP  string
+	MailFrom  string // SMTP MAIL FROM, empty for the null sender.
+	RcptTo    string // SMTP RCPT TO, possibly an alias address.
+	MsgFrom   string // Address in message From header.
+	Subject   string
+	MessageID string
+	Size      int64
+	Digested  time.Time // When the account was sent a digest including this message. Zero if not yet.
+}
+
+// QuarantineAdd adds a quarantined message, setting its ID.
+func QuarantineAdd(ctx context.Context, q *Quarantined) error {
+	db, err := database(ctx)
+	if err != nil {
+		return err
+	}
+	q.ID = 0
+	return db.Insert(ctx, q)
+}
+
+// QuarantineGet returns a quarantined message, or ErrNotFound.
+func QuarantineGet(ctx context.Context, id int64) (Quarantined, error) {
+	db, err := database(ctx)
+	if err != nil {
+		return Quarantined{}, err
+	}
+	q := Quarantined{ID: id}
+	err = db.Get(ctx, &q)
+	if err == bstore.ErrAbsent {
+		return Quarantined{}, fmt.Errorf("%w: no quarantined message with id %d", ErrNotFound, id)
+	}
+	return q, err
+}
+
+// QuarantineList returns quarantined messages, most recent first, optionally only
+// for an account.
+func QuarantineList(ctx context.Context, account string) ([]Quarantined, error) {
+	db, err := database(ctx)
+	if err != nil {
+		return nil, err
+	}
+	q := bstore.QueryDB[Quarantined](ctx, db)
+	if account != "" {
+		q.FilterNonzero(Quarantined{Account: account})
+	}
+	return q.SortDesc("Received", "ID").List()
+}
+
+// QuarantineExpired returns quarantined messages that expired before now.
+func QuarantineExpired(ctx context.Context, now time.Time) ([]Quarantined, error) {
+	db, err := database(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return bstore.QueryDB[Quarantined](ctx, db).FilterLess("Expires", now).List()
+}
+
+// QuarantineDigested marks the quarantined messages as included in a digest.
+func QuarantineDigested(ctx context.Context, ids []int64, now time.Time) error {
+	db, err := database(ctx)
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err = bstore.QueryDB[Quarantined](ctx, db).FilterIDs(ids).UpdateNonzero(Quarantined{Digested: now})
+	return err
+}
+
+// QuarantineRemove removes a quarantined message, returning ErrNotFound if it
+// does not exist.
+func QuarantineRemove(ctx context.Context, id int64) error {
+	db, err := database(ctx)
+	if err != nil {
+		return err
+	}
+	err = db.Delete(ctx, &Quarantined{ID: id})
+	if err == bstore.ErrAbsent {
+		return fmt.Errorf("%w: no quarantined message with id %d", ErrNotFound, id)
+	}
+	return err
+}
@@ -0,0 +1,73 @@
+package admindb
+
+import (
+	"context"
+	"time"
+
+	"github.com/mjl-/bstore"
+)
+
+// SpamtrapHit is a message delivered to a spamtrap address. Hits are used as
+// reputation for the remote network and sender domain of later messages.
+type SpamtrapHit struct {
+	ID            int64
+	Time          time.Time `bstore:"default now,index"`
+	Trap          string    `bstore:"nonzero,index"` // Spamtrap address.
+	RemoteIP      string
+	RemoteNetwork string `bstore:"index"` // Remote IP masked to IPv4 /26 or IPv6 /64.
+	EHLO          string
+	MailFrom      string // SMTP MAIL FROM, empty for the null sender.
+	Domain        string `bstore:"index"` // Organizational domain of message From address with DMARC pass, or of SPF-validated MAIL FROM. Empty if neither was validated.
+	MsgFrom       string // Address in message From header.
+	Subject       string
+	Size          int64
+}
+
+// SpamtrapHitAdd records a message delivered to a spamtrap, setting its ID.
+func SpamtrapHitAdd(ctx context.Context, h *SpamtrapHit) error {
+	db, err := database(ctx)
+	if err != nil {
+		return err
+	}
+	h.ID = 0
+	return db.Insert(ctx, h)
+}
+
+// SpamtrapHitList returns the most recent spamtrap hits, at most max if max > 0.
+func SpamtrapHitList(ctx context.Context, max int) ([]SpamtrapHit, error) {
+	db, err := database(ctx)
+	if err != nil {
+		return nil, err
+	}
+	q := bstore.QueryDB[SpamtrapHit](ctx, db)
+	q.SortDesc("Time", "ID")
+	if max > 0 {
+		q.Limit(max)
+	}
+	return q.List()
+}
+
+// SpamtrapHitsSince returns the number of spamtrap hits since a time for a remote
+// network or, if domain is not empty, for a validated sender domain.
+func SpamtrapHitsSince(ctx context.Context, since time.Time, network, domain string) (int, error) {
+	db, err := database(ctx)
+	if err != nil {
+		return 0, err
+	}
+	n, err := bstore.QueryDB[SpamtrapHit](ctx, db).FilterNonzero(SpamtrapHit{RemoteNetwork: network}).FilterGreaterEqual("Time", since).Count()
+	if err != nil || domain == "" {
+		return n, err
+	}
+	nd, err := bstore.QueryDB[SpamtrapHit](ctx, db).FilterNonzero(SpamtrapHit{Domain: domain}).FilterGreaterEqual("Time", since).Count()
+	return n + nd, err
+}
+
+// SpamtrapHitsRemoveBefore removes spamtrap hits older than a time, returning the
+// number removed.
+func SpamtrapHitsRemoveBefore(ctx context.Context, before time.Time) (int, error) {
+	db, err := database(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return bstore.QueryDB[SpamtrapHit](ctx, db).FilterLess("Time", before).Delete()
+}
@@ -0,0 +1,143 @@
+package admindb
+
+import (
+	"context"
+	"time"
+
+	"github.com/mjl-/bstore"
+)
+
+// SubmissionNetwork is a network an account has submitted messages from, for
+// detecting submissions from networks not used before by the account.
+type SubmissionNetwork struct {
+	ID      int64
+	Account string    `bstore:"nonzero,unique Account+Network"`
+	Network string    `bstore:"nonzero"` // IPv4 /16 or IPv6 /32, e.g. "198.51.0.0/16".
+	First   time.Time `bstore:"default now"`
+	Last    time.Time `bstore:"default now"`
+}
+
+// SubmissionIncident records anomalies detected during submission of messages by
+// an account, and the action taken.
+type SubmissionIncident struct {
+	ID        int64
+	Time      time.Time `bstore:"default now,index"`
+	Account   string    `bstore:"nonzero,index"`
+	Source    string    // "smtp", "webmail" or "webapi".
+	RemoteIP  string
+	Anomalies []string  // Descriptions of the detected anomalies.
+	Action    string    // "alert", "throttle" or "freeze".
+	Until     time.Time // For action "throttle", until when submissions are refused.
+	Cleared   bool      // Set when an admin clears a throttle before it ends.
+}
+
+// SubmissionNetworkSeen records that an account submitted from network, and
+// returns whether the network was new for the account, and whether the account
+// had submitted from any network before.
+func SubmissionNetworkSeen(ctx context.Context, account, network string) (isNew, haveOthers bool, rerr error) {
+	db, err := database(ctx)
+	if err != nil {
+		return false, false, err
+	}
+	err = db.Write(ctx, func(tx *bstore.Tx) error {
+		q := bstore.QueryTx[SubmissionNetwork](tx)
+		q.FilterNonzero(SubmissionNetwork{Account: account})
+		l, err := q.List()
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		for _, sn := range l {
+			if sn.Network == network {
+				sn.Last = now
+				return tx.Update(&sn)
+			}
+		}
+		isNew = true
+		haveOthers = len(l) > 0
+		return tx.Insert(&SubmissionNetwork{Account: account, Network: network, First: now, Last: now})
+	})
+	return isNew, haveOthers, err
+}
+
+// SubmissionIncidentAdd adds an incident.
+func SubmissionIncidentAdd(ctx context.Context, si *SubmissionIncident) error {
+	db, err := database(ctx)
+	if err != nil {
+		return err
+	}
+	si.ID = 0
+	return db.Insert(ctx, si)
+}
+
+// SubmissionIncidentList returns incidents, most recent first, optionally only
+// for an account, and limited to max entries if max is > 0.
+func SubmissionIncidentList(ctx context.Context, account string, max int) ([]SubmissionIncident, error) {
+	db, err := database(ctx)
+	if err != nil {
+		return nil, err
+	}
+	q := bstore.QueryDB[SubmissionIncident](ctx, db)
+	if account != "" {
+		q.FilterNonzero(SubmissionIncident{Account: account})
+	}
+	q.SortDesc("Time", "ID")
+	if max > 0 {
+		q.Limit(max)
+	}
+	return q.List()
+}
+
+// SubmissionThrottled returns the incident that throttles submissions for
+// account, or ErrNotFound if the account is not throttled.
+func SubmissionThrottled(ctx context.Context, account string) (SubmissionIncident, error) {
+	db, err := database(ctx)
+	if err != nil {
+		return SubmissionIncident{}, err
+	}
+	q := bstore.QueryDB[SubmissionIncident](ctx, db)
+	q.FilterNonzero(SubmissionIncident{Account: account, Action: "throttle"})
+	q.FilterEqual("Cleared", false)
+	q.FilterGreater("Until", time.Now())
+	q.SortDesc("Until")
+	q.Limit(1)
+	si, err := q.Get()
+	if err == bstore.ErrAbsent {
+		return SubmissionIncident{}, ErrNotFound
+	}
+	return si, err
+}
+
+// SubmissionThrottleClear ends active throttles for an account. It returns the
+// number of cleared incidents.
+func SubmissionThrottleClear(ctx context.Context, account string) (int, error) {
+	db, err := database(ctx)
+	if err != nil {
+		return 0, err
+	}
+	q := bstore.QueryDB[SubmissionIncident](ctx, db)
+	q.FilterNonzero(SubmissionIncident{Account: account, Action: "throttle"})
+	q.FilterEqual("Cleared", false)
+	q.FilterGreater("Until", time.Now())
+	return q.UpdateNonzero(SubmissionIncident{Cleared: true})
+}
+
+// SubmissionPurge removes the submission networks and incidents of an account,
+// when the account is purged.
+func SubmissionPurge(ctx context.Context, account string) (int, error) {
+	db, err := database(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	err = db.Write(ctx, func(tx *bstore.Tx) error {
+		nn, err := bstore.QueryTx[SubmissionNetwork](tx).FilterNonzero(SubmissionNetwork{Account: account}).Delete()
+		if err != nil {
+			return err
+		}
+		ni, err := bstore.QueryTx[SubmissionIncident](tx).FilterNonzero(SubmissionIncident{Account: account}).Delete()
+		n = nn + ni
+		return err
+	})
+	return n, err
+}
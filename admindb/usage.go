@@ -0,0 +1,99 @@
+package admindb
+
+import (
+	"context"
+	"time"
+
+	"github.com/mjl-/bstore"
+)
+
+// Usage holds the usage of an account for a domain in a calendar month, for
+// usage reporting and billing. Usage records are not removed automatically.
+type Usage struct {
+	ID    int64
+	Month string `bstore:"unique Month+Account+Domain,nonzero"` // In UTC, e.g. "2026-10".
+
+	Account string `bstore:"nonzero,index"`
+
+	// Domain of the recipient address for received messages, of the sender address
+	// for sent messages, and the default domain of the account for stored bytes.
+	// Unicode.
+	Domain string `bstore:"nonzero,index"`
+
+	MessagesReceived int64 // Incoming messages delivered to the account, per recipient.
+	BytesReceived    int64 // Total size of received messages.
+	MessagesSent     int64 // Outgoing messages delivered to remote mail servers, per recipient.
+	BytesSent        int64 // Total size of sent messages, i.e. outgoing bandwidth.
+	StoredBytes      int64 // Highest sampled total size of messages in the account during the month.
+
+	Updated time.Time `bstore:"default now"`
+}
+
+// UsageMonth returns the month for usage at time t.
+func UsageMonth(t time.Time) string {
+	return t.UTC().Format("2006-01")
+}
+
+// UsageAdd adds the counters of delta to the usage of account for domain in the
+// month of time now. For StoredBytes, the highest value is kept.
+func UsageAdd(ctx context.Context, now time.Time, account, domain string, delta Usage) error {
+	db, err := database(ctx)
+	if err != nil {
+		return err
+	}
+	return db.Write(ctx, func(tx *bstore.Tx) error {
+		month := UsageMonth(now)
+		q := bstore.QueryTx[Usage](tx)
+		q.FilterEqual("Month", month)
+		q.FilterEqual("Account", account)
+		q.FilterEqual("Domain", domain)
+		u, err := q.Get()
+		if err == bstore.ErrAbsent {
+			u = Usage{Month: month, Account: account, Domain: domain}
+		} else if err != nil {
+			return err
+		}
+		u.MessagesReceived += delta.MessagesReceived
+		u.BytesReceived += delta.BytesReceived
+		u.MessagesSent += delta.MessagesSent
+		u.BytesSent += delta.BytesSent
+		u.StoredBytes = max(u.StoredBytes, delta.StoredBytes)
+		u.Updated = now
+		if u.ID == 0 {
+			return tx.Insert(&u)
+		}
+		return tx.Update(&u)
+	})
+}
+
+// UsageFilter selects usage records. Zero values don't filter.
+type UsageFilter struct {
+	Start   string // First month, e.g. "2026-01".
+	End     string // Last month, inclusive.
+	Account string
+	Domain  string
+}
+
+// UsageList returns usage records matching the filter, sorted by month, account
+// and domain.
+func UsageList(ctx context.Context, f UsageFilter) ([]Usage, error) {
+	db, err := database(ctx)
+	if err != nil {
+		return nil, err
+	}
+	q := bstore.QueryDB[Usage](ctx, db)
+	if f.Start != "" {
+		q.FilterGreaterEqual("Month", f.Start)
+	}
+	if f.End != "" {
+		q.FilterLessEqual("Month", f.End)
+	}
+	if f.Account != "" {
+		q.FilterEqual("Account", f.Account)
+	}
+	if f.Domain != "" {
+		q.FilterEqual("Domain", f.Domain)
+	}
+	q.SortAsc("Month", "Account", "Domain")
+	return q.List()
+}
@@ -0,0 +1,487 @@
+// Package alert notifies the postmaster about operational problems, by email
+// and/or webhook.
+//
+// Problems are checked periodically: ACME certificates that are close to
+// expiring, a large outgoing queue, low free disk space and many failed
+// authentication attempts. Other components raise alerts too, e.g. the DNSBL
+// monitor when an IP we send from is listed. While a problem persists, its alert
+// is repeated at the configured interval. Once resolved, a next occurrence is
+// alerted immediately.
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/dnsbl"
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/moxvar"
+	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/store"
+)
+
+var (
+	metricAlert = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mox_alert_total",
+			Help: "Alerts sent about operational problems, per kind.",
+		},
+		[]string{
+			"kind", // certificate, queue, dnsbl, disk, authfailures
+		},
+	)
+	metricAlertError = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mox_alert_errors_total",
+			Help: "Errors sending alerts, per channel.",
+		},
+		[]string{
+			"channel", // postmaster, email, webhook
+		},
+	)
+)
+
+// Kinds of alerts.
+const (
+	KindCertificate  = "certificate"
+	KindQueue        = "queue"
+	KindDNSBL        = "dnsbl"
+	KindDisk         = "disk"
+	KindAuthFailures = "authfailures"
+)
+
+// Alert is a notification about a problem, as sent in webhook requests with the
+// json format.
+type Alert struct {
+	Kind    string    // E.g. certificate, queue, dnsbl, disk, authfailures.
+	Key     string    // Identifies the problem within the kind, e.g. the host of a certificate. Can be empty.
+	Subject string    // Short description.
+	Text    string    // Details, possibly multiple lines.
+	Time    time.Time // When the alert was raised.
+}
+
+type conditionKey struct {
+	kind, key string
+}
+
+// Problems that are currently active, with the time their alert was last sent.
+var active = struct {
+	sync.Mutex
+	conditions map[conditionKey]time.Time
+}{conditions: map[conditionKey]time.Time{}}
+
+// Number of failed authentication attempts at the previous check.
+var prevAuthFailures int64
+
+// errDiskUnsupported is returned by diskUsage on platforms where free disk space
+// cannot be determined.
+var errDiskUnsupported = errors.New("disk usage not supported on this platform")
+
+// Raise notifies about a problem, unless an alert for the same kind and key was
+// already sent within the repeat interval. If alerting is not configured, nothing
+// is sent.
+func Raise(log mlog.Log, kind, key, subject, text string) {
+	c := mox.Conf.Static.Alerting
+	if c == nil {
+		return
+	}
+	repeat := c.RepeatInterval
+	if repeat == 0 {
+		repeat = 24 * time.Hour
+	}
+
+	ck := conditionKey{kind, key}
+	active.Lock()
+	last, ok := active.conditions[ck]
+	if ok && time.Since(last) < repeat {
+		active.Unlock()
+		return
+	}
+	active.conditions[ck] = time.Now()
+	active.Unlock()
+
+	a := Alert{kind, key, subject, text, time.Now()}
+	log.Info("sending alert", slog.String("kind", kind), slog.String("key", key), slog.String("subject", subject))
+	metricAlert.WithLabelValues(kind).Inc()
+	send(log, *c, a)
+}
+
+// Resolve marks a problem as resolved, so a next occurrence is alerted
+// immediately.
+func Resolve(kind, key string) {
+	active.Lock()
+	defer active.Unlock()
+	delete(active.conditions, conditionKey{kind, key})
+}
+
+// Start periodically checks for problems, if alerting is configured. The
+// configuration is read before each check, so alerting can be enabled or changed
+// by reloading the config file.
+func Start() {
+	prevAuthFailures = metrics.AuthenticationFailures()
+
+	log := mlog.New("alert", nil)
+	go func() {
+		// Give startup, e.g. requesting certificates, some time.
+		timer := time.NewTimer(time.Minute)
+		defer timer.Stop()
+		for {
+			select {
+			case <-mox.Shutdown.Done():
+				return
+			case <-timer.C:
+			}
+			interval := 5 * time.Minute
+			if c := mox.Conf.Static.Alerting; c != nil {
+				check(log.WithCid(mox.Cid()), *c)
+				if c.CheckInterval > 0 {
+					interval = c.CheckInterval
+				}
+			} else {
+				// Don't alert about failures from before alerting was enabled.
+				prevAuthFailures = metrics.AuthenticationFailures()
+			}
+			timer.Reset(interval)
+		}
+	}()
+}
+
+func check(log mlog.Log, c config.Alerting) {
+	defer func() {
+		x := recover()
+		if x != nil {
+			log.Error("unhandled panic in alert check", slog.Any("x", x))
+			debug.PrintStack()
+			metrics.PanicInc(metrics.Alert)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(mox.Shutdown, time.Minute)
+	defer cancel()
+
+	checkCertificates(ctx, log, c)
+	checkQueue(ctx, log, c)
+	checkDisk(log, c)
+	checkAuthFailures(log, c)
+}
+
+func checkCertificates(ctx context.Context, log mlog.Log, c config.Alerting) {
+	days := c.CertExpiryDays
+	if days == 0 {
+		days = 14
+	}
+	for name, acme := range mox.Conf.Static.ACME {
+		if acme.Manager == nil {
+			continue
+		}
+		for _, host := range acme.Manager.Hostnames() {
+			exp, err := acme.Manager.CertExpiration(ctx, host)
+			if err != nil {
+				log.Errorx("checking certificate expiration for alert", err, slog.Any("host", host))
+				continue
+			}
+			// Certificates that were never requested are not a problem yet.
+			if exp.IsZero() || time.Until(exp) > time.Duration(days)*24*time.Hour {
+				Resolve(KindCertificate, host.Name())
+				continue
+			}
+			subject := fmt.Sprintf("TLS certificate for %s expires soon", host)
+			text := fmt.Sprintf(`The TLS certificate for %s, obtained through ACME provider %q, expires at
+%s. Certificates are renewed before they expire, so renewal has failed.
+Check the log for errors requesting certificates, e.g. due to DNS records or
+port 443 not reaching this server.`, host, name, exp.Format(time.RFC3339))
+			Raise(log, KindCertificate, host.Name(), subject, text)
+		}
+	}
+}
+
+func checkQueue(ctx context.Context, log mlog.Log, c config.Alerting) {
+	max := c.QueueSize
+	if max == 0 {
+		max = 1000
+	}
+	n, err := queue.Count(ctx)
+	if err != nil {
+		log.Errorx("counting messages in queue for alert", err)
+		return
+	}
+	if n <= max {
+		Resolve(KindQueue, "")
+		return
+	}
+	subject := fmt.Sprintf("%d messages in queue", n)
+	text := fmt.Sprintf(`The outgoing queue has %d messages, more than the threshold of %d. Remote
+servers may be refusing messages from this server, or an account may be sending
+spam. Check the queue in the admin web interface.`, n, max)
+	Raise(log, KindQueue, "", subject, text)
+}
+
+func checkDisk(log mlog.Log, c config.Alerting) {
+	min := c.DiskFreePercent
+	if min == 0 {
+		min = 10
+	}
+	dir := mox.DataDirPath("")
+	free, total, err := diskUsage(dir)
+	if err == errDiskUnsupported {
+		return
+	} else if err != nil {
+		log.Errorx("checking free disk space for alert", err)
+		return
+	} else if total == 0 {
+		return
+	}
+	pct := int(free * 100 / total)
+	if pct >= min {
+		Resolve(KindDisk, dir)
+		return
+	}
+	subject := fmt.Sprintf("disk nearly full, %d%% free", pct)
+	text := fmt.Sprintf(`The file system of data directory %s has %d%% free space (%d MB of %d MB), less
+than the threshold of %d%%. When the disk is full, incoming messages are
+rejected.`, dir, pct, free/(1024*1024), total/(1024*1024), min)
+	Raise(log, KindDisk, dir, subject, text)
+}
+
+func checkAuthFailures(log mlog.Log, c config.Alerting) {
+	max := c.AuthFailures
+	if max == 0 {
+		max = 100
+	}
+	n := metrics.AuthenticationFailures()
+	delta := n - prevAuthFailures
+	prevAuthFailures = n
+	if delta <= int64(max) {
+		Resolve(KindAuthFailures, "")
+		return
+	}
+	subject := fmt.Sprintf("%d failed authentication attempts", delta)
+	text := fmt.Sprintf(`There were %d failed or rate limited authentication attempts since the previous
+check, more than the threshold of %d. Someone may be trying to guess passwords.
+Check the log for the remote IPs and accounts, and the metrics
+mox_authentication_total and mox_authentication_ratelimited_total.`, delta, max)
+	Raise(log, KindAuthFailures, "", subject, text)
+}
+
+// send delivers the alert to the postmaster mailbox, and sends it to the
+// configured email addresses and webhook.
+func send(log mlog.Log, c config.Alerting, a Alert) {
+	from := smtp.Address{Localpart: "postmaster", Domain: mox.Conf.Static.HostnameDomain}
+	var rcpts []smtp.Address
+	for _, s := range c.Email {
+		addr, err := smtp.ParseAddress(s)
+		if err != nil {
+			log.Errorx("parsing alert email address", err, slog.String("address", s))
+			continue
+		}
+		rcpts = append(rcpts, addr)
+	}
+
+	msg, has8bit, smtputf8, messageID, err := compose(from, rcpts, a)
+	if err != nil {
+		log.Errorx("composing alert message", err)
+		metricAlertError.WithLabelValues("postmaster").Inc()
+	} else {
+		if err := deliverPostmaster(log, msg); err != nil {
+			log.Errorx("delivering alert to postmaster", err)
+			metricAlertError.WithLabelValues("postmaster").Inc()
+		}
+		if len(rcpts) > 0 {
+			if err := queueEmail(log, from, rcpts, msg, has8bit, smtputf8, messageID, a.Subject); err != nil {
+				log.Errorx("queueing alert email", err)
+				metricAlertError.WithLabelValues("email").Inc()
+			}
+		}
+	}
+
+	if c.WebhookURL != "" {
+		if err := sendWebhook(c, a); err != nil {
+			log.Errorx("sending alert webhook", err)
+			metricAlertError.WithLabelValues("webhook").Inc()
+		}
+	}
+}
+
+func compose(from smtp.Address, rcpts []smtp.Address, a Alert) (msg []byte, has8bit, smtputf8 bool, messageID string, rerr error) {
+	for _, r := range rcpts {
+		smtputf8 = smtputf8 || r.Localpart.IsInternational()
+	}
+
+	var b bytes.Buffer
+	xc := message.NewComposer(&b, 0, smtputf8)
+	defer func() {
+		x := recover()
+		if x == nil {
+			return
+		}
+		if err, ok := x.(error); ok && errors.Is(err, message.ErrCompose) {
+			rerr = err
+			return
+		}
+		panic(x)
+	}()
+
+	xc.HeaderAddrs("From", []message.NameAddress{{Address: from}})
+	if len(rcpts) == 0 {
+		rcpts = []smtp.Address{from}
+	}
+	var to []message.NameAddress
+	for _, r := range rcpts {
+		to = append(to, message.NameAddress{Address: r})
+	}
+	xc.HeaderAddrs("To", to)
+	xc.Subject("mox: " + a.Subject)
+	messageID = fmt.Sprintf("<%s>", mox.MessageIDGen(xc.SMTPUTF8))
+	xc.Header("Message-Id", messageID)
+	xc.Header("Date", a.Time.Format(message.RFC5322Z))
+	xc.Header("User-Agent", "mox/"+moxvar.Version)
+	xc.Header("MIME-Version", "1.0")
+
+	text := fmt.Sprintf("Hi!\n\n%s\n\nThis alert is repeated while the problem persists.\n\nCheers,\nmox\n", a.Text)
+	textBody, ct, cte := xc.TextPart("plain", text)
+	xc.Header("Content-Type", ct)
+	xc.Header("Content-Transfer-Encoding", cte)
+	xc.Line()
+	xc.Write(textBody)
+	xc.Flush()
+
+	return b.Bytes(), xc.Has8bit, xc.SMTPUTF8, messageID, nil
+}
+
+func deliverPostmaster(log mlog.Log, msg []byte) error {
+	acc, err := store.OpenAccount(log, mox.Conf.Static.Postmaster.Account)
+	if err != nil {
+		return fmt.Errorf("open postmaster account: %v", err)
+	}
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+	f, err := store.CreateMessageTemp(log, "alert")
+	if err != nil {
+		return fmt.Errorf("creating temporary message file: %v", err)
+	}
+	defer store.CloseRemoveTempFile(log, f, "alert message for postmaster")
+	if _, err := f.Write(msg); err != nil {
+		return fmt.Errorf("writing temporary message file: %v", err)
+	}
+
+	m := store.Message{
+		Received: time.Now(),
+		Size:     int64(len(msg)),
+		Flags:    store.Flags{Flagged: true},
+	}
+	acc.WithWLock(func() {
+		err = acc.DeliverMailbox(log, mox.Conf.Static.Postmaster.Mailbox, &m, f)
+	})
+	return err
+}
+
+func queueEmail(log mlog.Log, from smtp.Address, rcpts []smtp.Address, msg []byte, has8bit, smtputf8 bool, messageID, subject string) error {
+	ctx, cancel := context.WithTimeout(mox.Shutdown, time.Minute)
+	defer cancel()
+
+	dkimHeaders, err := mox.DKIMSign(ctx, log, from.Path(), smtputf8, msg)
+	log.Check(err, "dkim signing alert message")
+
+	f, err := store.CreateMessageTemp(log, "alert")
+	if err != nil {
+		return fmt.Errorf("creating temporary message file: %v", err)
+	}
+	defer store.CloseRemoveTempFile(log, f, "alert message for queue")
+	if _, err := f.Write(msg); err != nil {
+		return fmt.Errorf("writing temporary message file: %v", err)
+	}
+
+	size := int64(len(dkimHeaders) + len(msg))
+	var qml []queue.Msg
+	for _, rcpt := range rcpts {
+		qm := queue.MakeMsg(from.Path(), rcpt.Path(), has8bit, smtputf8, size, messageID, []byte(dkimHeaders), nil, time.Now(), subject)
+		qml = append(qml, qm)
+	}
+	return queue.Add(ctx, log, mox.Conf.Static.Postmaster.Account, f, qml...)
+}
+
+func sendWebhook(c config.Alerting, a Alert) error {
+	var body []byte
+	var ct string
+	switch c.WebhookFormat {
+	case "", "json":
+		buf, err := json.Marshal(a)
+		if err != nil {
+			return fmt.Errorf("marshal alert: %v", err)
+		}
+		body, ct = buf, "application/json"
+	case "slack":
+		buf, err := json.Marshal(map[string]string{"text": "*" + a.Subject + "*\n" + a.Text})
+		if err != nil {
+			return fmt.Errorf("marshal alert: %v", err)
+		}
+		body, ct = buf, "application/json"
+	case "text":
+		body, ct = []byte(a.Text), "text/plain; charset=utf-8"
+	default:
+		return fmt.Errorf("unknown webhook format %q", c.WebhookFormat)
+	}
+
+	ctx, cancel := context.WithTimeout(mox.Shutdown, 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", c.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("new request: %v", err)
+	}
+	req.Header.Set("Content-Type", ct)
+	req.Header.Set("User-Agent", "mox/"+moxvar.Version)
+	if c.WebhookFormat == "text" {
+		req.Header.Set("Title", "mox on "+mox.Conf.Static.HostnameDomain.ASCII+": "+a.Subject)
+	}
+	for k, v := range c.WebhookHeaders {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("http request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("http response status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// DNSBLStatus raises an alert if ip is listed in zone, and resolves it when no
+// longer listed.
+func DNSBLStatus(log mlog.Log, zone dns.Domain, ip string, listed bool, explanation string) {
+	key := zone.Name() + " " + ip
+	if !listed {
+		Resolve(KindDNSBL, key)
+		return
+	}
+	subject := fmt.Sprintf("IP %s listed in DNSBL %s", ip, zone)
+	text := fmt.Sprintf(`IP %s, used for sending messages, is listed in DNS block list %s.
+Messages sent from this IP may be rejected or classified as spam by receiving
+servers. Explanation from the block list: %s
+
+%s`, ip, zone, explanation, dnsbl.DelistText(zone, ip))
+	Raise(log, KindDNSBL, key, subject, text)
+}
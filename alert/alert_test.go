@@ -0,0 +1,126 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/store"
+)
+
+var ctxbg = context.Background()
+
+func tcheck(t *testing.T, err error, msg string) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %s", msg, err)
+	}
+}
+
+func tcompare(t *testing.T, got, exp any) {
+	t.Helper()
+	if got != exp {
+		t.Fatalf("got %v, expected %v", got, exp)
+	}
+}
+
+func TestAlert(t *testing.T) {
+	log := mlog.New("alert", nil)
+	os.RemoveAll("../testdata/alert/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/alert/mox.conf")
+	mox.ConfigDynamicPath = filepath.FromSlash("../testdata/alert/domains.conf")
+	mox.MustLoadConfig(true, false)
+	defer store.Switchboard()()
+	err := queue.Init()
+	tcheck(t, err, "queue init")
+	defer queue.Shutdown()
+
+	var mu sync.Mutex
+	var alerts []Alert
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var a Alert
+		err := json.NewDecoder(r.Body).Decode(&a)
+		tcheck(t, err, "decode alert")
+		mu.Lock()
+		defer mu.Unlock()
+		alerts = append(alerts, a)
+	}))
+	defer srv.Close()
+	c := mox.Conf.Static.Alerting
+	c.WebhookURL = srv.URL
+
+	nwebhooks := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(alerts)
+	}
+
+	acc, err := store.OpenAccount(log, "mjl")
+	tcheck(t, err, "open account")
+	defer func() {
+		err := acc.Close()
+		tcheck(t, err, "close account")
+		acc.CheckClosed()
+	}()
+	npostmaster := func() int {
+		t.Helper()
+		var n int
+		err := acc.DB.Read(ctxbg, func(tx *bstore.Tx) error {
+			mb, err := acc.MailboxFind(tx, "postmaster")
+			if err != nil || mb == nil {
+				return err
+			}
+			n, err = bstore.QueryTx[store.Message](tx).FilterNonzero(store.Message{MailboxID: mb.ID}).FilterEqual("Expunged", false).Count()
+			return err
+		})
+		tcheck(t, err, "count postmaster messages")
+		return n
+	}
+
+	Raise(log, KindQueue, "", "test", "test alert")
+	tcompare(t, nwebhooks(), 1)
+	tcompare(t, npostmaster(), 1)
+	n, err := queue.Count(ctxbg)
+	tcheck(t, err, "queue count")
+	tcompare(t, n, 1)
+	msgs, err := queue.List(ctxbg, queue.Filter{}, queue.Sort{})
+	tcheck(t, err, "queue list")
+	tcompare(t, msgs[0].Recipient().String(), "alerts@other.example")
+
+	// Not repeated while active.
+	Raise(log, KindQueue, "", "test", "test alert")
+	tcompare(t, nwebhooks(), 1)
+
+	// After resolving, the next occurrence is alerted again.
+	Resolve(KindQueue, "")
+	Raise(log, KindQueue, "", "test", "test alert")
+	tcompare(t, nwebhooks(), 2)
+
+	// Failed authentication attempts since previous check.
+	prevAuthFailures = metrics.AuthenticationFailures()
+	checkAuthFailures(log, *c)
+	tcompare(t, nwebhooks(), 2)
+	for i := 0; i < 3; i++ {
+		metrics.AuthenticationInc("imap", "login", "badcreds")
+	}
+	checkAuthFailures(log, *c)
+	tcompare(t, nwebhooks(), 3)
+	mu.Lock()
+	tcompare(t, alerts[2].Kind, KindAuthFailures)
+	mu.Unlock()
+	checkAuthFailures(log, *c)
+	metrics.AuthenticationRatelimitedInc("imap")
+	checkAuthFailures(log, *c)
+	tcompare(t, nwebhooks(), 3)
+}
@@ -0,0 +1,7 @@
+//go:build !linux && !darwin && !freebsd
+
+package alert
+
+func diskUsage(path string) (free, total uint64, err error) {
+	return 0, 0, errDiskUnsupported
+}
@@ -0,0 +1,17 @@
+//go:build linux || darwin || freebsd
+
+package alert
+
+import (
+	"syscall"
+)
+
+// diskUsage returns the free and total bytes of the file system holding path.
+// Free bytes are those available to unprivileged users.
+func diskUsage(path string) (free, total uint64, err error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), uint64(st.Blocks) * uint64(st.Bsize), nil
+}
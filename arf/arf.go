@@ -0,0 +1,287 @@
+// Package arf parses and formats email feedback reports in the Abuse Reporting
+// Format (ARF), RFC 5965, including authentication failure reports, RFC 6591.
+//
+// Feedback reports are sent by mailbox providers, e.g. through feedback loops,
+// when their users mark a message as spam, and by others reporting abuse. A
+// report is a multipart/report message with a human-readable part, a
+// machine-readable message/feedback-report part, and the original message or its
+// headers.
+package arf
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/moxio"
+)
+
+var ErrNoReport = errors.New("no feedback report found in message")
+
+// FeedbackType indicates the kind of report. Values are lower-case.
+type FeedbackType string
+
+const (
+	FeedbackAbuse       FeedbackType = "abuse"        // Unsolicited email, e.g. marked as spam by a user.
+	FeedbackFraud       FeedbackType = "fraud"        // Fraudulent email, e.g. phishing.
+	FeedbackVirus       FeedbackType = "virus"        // Email with malware.
+	FeedbackOther       FeedbackType = "other"        // Any other kind of report.
+	FeedbackNotSpam     FeedbackType = "not-spam"     // Email was incorrectly marked as spam, RFC 6430.
+	FeedbackAuthFailure FeedbackType = "auth-failure" // Failed authentication checks, e.g. DMARC failure reports, RFC 6591.
+)
+
+// Report is a parsed feedback report.
+type Report struct {
+	// Fields from the message/feedback-report part. Only FeedbackType is required
+	// to be present.
+	FeedbackType          FeedbackType
+	UserAgent             string
+	Version               string
+	OriginalMailFrom      string   // SMTP MAIL FROM of original message, without <>.
+	OriginalRcptTo        []string // SMTP RCPT TO of original message, without <>. Often left out or redacted.
+	ArrivalDate           time.Time
+	ReportingMTA          string
+	SourceIP              string
+	Incidents             int // Number of incidents this report represents. Default 1.
+	AuthenticationResults []string
+	ReportedDomain        []string
+	ReportedURI           []string
+
+	// Fields for authentication failure reports, RFC 6591 and RFC 7489.
+	AuthFailure       string // E.g. "dmarc", "signature", "bodyhash", "spf".
+	DeliveryResult    string // E.g. "delivered", "spam", "policy", "reject", "other".
+	DKIMDomain        string
+	DKIMIdentity      string
+	DKIMSelector      string
+	IdentityAlignment string // "none", or "dkim" and/or "spf" separated by comma.
+
+	// Text from the human-readable part.
+	Text string
+
+	// From the headers of the original message, if present in the report.
+	OriginalMessageID string // Includes <>.
+	OriginalFrom      string
+	OriginalTo        string
+	OriginalSubject   string
+}
+
+// Parse parses a feedback report from a mail message. The maximum message size
+// is 15MB.
+//
+// ErrNoReport is returned if the message is not a multipart/report with report
+// type feedback-report.
+func Parse(elog *slog.Logger, r io.ReaderAt) (*Report, error) {
+	log := mlog.New("arf", elog)
+
+	part, err := message.Parse(log.Logger, false, &moxio.LimitAtReader{R: r, Limit: 15 * 1024 * 1024})
+	if err != nil {
+		return nil, fmt.Errorf("parsing message: %v", err)
+	}
+	if part.MediaType != "MULTIPART" || part.MediaSubType != "REPORT" || !strings.EqualFold(part.ContentTypeParams["report-type"], "feedback-report") {
+		return nil, ErrNoReport
+	}
+
+	// First part is human-readable, typically text/plain.
+	p0, err := part.ParseNextPart(log.Logger)
+	if err != nil {
+		return nil, fmt.Errorf("parsing first part: %v", err)
+	}
+	buf, err := io.ReadAll(p0.ReaderUTF8OrBinary())
+	if err != nil {
+		return nil, fmt.Errorf("reading human-readable part: %v", err)
+	}
+	text := strings.ReplaceAll(string(buf), "\r\n", "\n")
+
+	p1, err := part.ParseNextPart(log.Logger)
+	if err != nil {
+		return nil, fmt.Errorf("parsing second part: %v", err)
+	}
+	if p1.MediaType != "MESSAGE" || p1.MediaSubType != "FEEDBACK-REPORT" {
+		return nil, fmt.Errorf(`second part has content-type %q, must have "message/feedback-report"`, strings.ToLower(p1.MediaType+"/"+p1.MediaSubType))
+	}
+	report, err := Decode(p1.Reader())
+	if err != nil {
+		return nil, fmt.Errorf("parsing feedback-report part: %v", err)
+	}
+	report.Text = text
+
+	// Optional third part with the original message or its headers.
+	p2, err := part.ParseNextPart(log.Logger)
+	if err == io.EOF {
+		return report, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("parsing third part: %v", err)
+	}
+	ct := strings.ToLower(p2.MediaType + "/" + p2.MediaSubType)
+	switch ct {
+	case "message/rfc822", "message/global", "text/rfc822-headers", "message/global-headers":
+	default:
+		return nil, fmt.Errorf("invalid content-type %q for third part with original message/headers", ct)
+	}
+	// The original message is commonly truncated or redacted, we only need its
+	// headers.
+	msg, err := mail.ReadMessage(bufio.NewReader(io.MultiReader(p2.Reader(), strings.NewReader("\r\n"))))
+	if err != nil {
+		return nil, fmt.Errorf("parsing headers of original message: %v", err)
+	}
+	var dec mime.WordDecoder
+	decode := func(s string) string {
+		if ds, err := dec.DecodeHeader(s); err == nil {
+			return ds
+		}
+		return s
+	}
+	report.OriginalMessageID = strings.TrimSpace(msg.Header.Get("Message-Id"))
+	report.OriginalFrom = decode(msg.Header.Get("From"))
+	report.OriginalTo = decode(msg.Header.Get("To"))
+	report.OriginalSubject = decode(msg.Header.Get("Subject"))
+	return report, nil
+}
+
+// Decode parses the fields of a message/feedback-report part.
+func Decode(r io.Reader) (*Report, error) {
+	// We are using textproto.Reader to read the fields. It requires a header section
+	// ending in \r\n.
+	br := bufio.NewReader(io.MultiReader(r, strings.NewReader("\r\n")))
+	h, err := textproto.NewReader(br).ReadMIMEHeader()
+	if err != nil {
+		return nil, fmt.Errorf("reading fields: %v", err)
+	}
+
+	path := func(s string) string {
+		return strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(s), "<"), ">")
+	}
+
+	rep := Report{Incidents: 1}
+	for k, l := range h {
+		v := strings.TrimSpace(l[0])
+		// note: field names are in canonical form, as parsed by textproto.
+		switch k {
+		case "Feedback-Type":
+			rep.FeedbackType = FeedbackType(strings.ToLower(v))
+		case "User-Agent":
+			rep.UserAgent = v
+		case "Version":
+			rep.Version = v
+		case "Original-Mail-From":
+			rep.OriginalMailFrom = path(v)
+		case "Original-Rcpt-To":
+			for _, s := range l {
+				rep.OriginalRcptTo = append(rep.OriginalRcptTo, path(s))
+			}
+		case "Arrival-Date", "Received-Date":
+			// Received-Date is from drafts of the specification, still used by some.
+			if t, err := mail.ParseDate(v); err == nil {
+				rep.ArrivalDate = t
+			}
+		case "Reporting-Mta":
+			// Of the form "dns; mx.example.org".
+			if t := strings.SplitN(v, ";", 2); len(t) == 2 {
+				v = strings.TrimSpace(t[1])
+			}
+			rep.ReportingMTA = v
+		case "Source-Ip":
+			// Some reporters enclose the IP in brackets.
+			v = strings.TrimSuffix(strings.TrimPrefix(v, "["), "]")
+			if ip := net.ParseIP(v); ip == nil {
+				return nil, fmt.Errorf("invalid source-ip %q", v)
+			}
+			rep.SourceIP = v
+		case "Incidents":
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid incidents %q", v)
+			}
+			rep.Incidents = n
+		case "Authentication-Results":
+			rep.AuthenticationResults = l
+		case "Reported-Domain":
+			rep.ReportedDomain = l
+		case "Reported-Uri":
+			rep.ReportedURI = l
+		case "Auth-Failure":
+			rep.AuthFailure = strings.ToLower(v)
+		case "Delivery-Result":
+			rep.DeliveryResult = strings.ToLower(v)
+		case "Dkim-Domain":
+			rep.DKIMDomain = v
+		case "Dkim-Identity":
+			rep.DKIMIdentity = v
+		case "Dkim-Selector":
+			rep.DKIMSelector = v
+		case "Identity-Alignment":
+			rep.IdentityAlignment = strings.ToLower(v)
+		default:
+			// Extension fields, ignored.
+		}
+	}
+	// Version and User-Agent are required too, but we don't need them and not all
+	// reporters include them.
+	if rep.FeedbackType == "" {
+		return nil, fmt.Errorf("missing required field Feedback-Type")
+	}
+	return &rep, nil
+}
+
+// Fields returns the report as the contents of a message/feedback-report part,
+// with CRLF line endings. The human-readable text and the fields about the
+// original message headers are not included.
+func (r Report) Fields() string {
+	var b strings.Builder
+	add := func(k, v string) {
+		if v != "" {
+			fmt.Fprintf(&b, "%s: %s\r\n", k, v)
+		}
+	}
+	path := func(s string) string {
+		if s == "" {
+			return ""
+		}
+		return "<" + s + ">"
+	}
+
+	add("Feedback-Type", string(r.FeedbackType))
+	add("User-Agent", r.UserAgent)
+	add("Version", r.Version)
+	add("Auth-Failure", r.AuthFailure)
+	add("Original-Mail-From", path(r.OriginalMailFrom))
+	for _, s := range r.OriginalRcptTo {
+		add("Original-Rcpt-To", path(s))
+	}
+	if !r.ArrivalDate.IsZero() {
+		add("Arrival-Date", r.ArrivalDate.Format(message.RFC5322Z))
+	}
+	if r.ReportingMTA != "" {
+		add("Reporting-MTA", "dns; "+r.ReportingMTA)
+	}
+	add("Source-IP", r.SourceIP)
+	if r.Incidents > 1 {
+		add("Incidents", fmt.Sprintf("%d", r.Incidents))
+	}
+	add("Delivery-Result", r.DeliveryResult)
+	for _, s := range r.AuthenticationResults {
+		add("Authentication-Results", s)
+	}
+	add("Identity-Alignment", r.IdentityAlignment)
+	add("DKIM-Domain", r.DKIMDomain)
+	add("DKIM-Identity", r.DKIMIdentity)
+	add("DKIM-Selector", r.DKIMSelector)
+	for _, s := range r.ReportedDomain {
+		add("Reported-Domain", s)
+	}
+	for _, s := range r.ReportedURI {
+		add("Reported-URI", s)
+	}
+	return b.String()
+}
@@ -0,0 +1,157 @@
+package arf
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Based on the example in RFC 5965, appendix B.2.
+const reportMessage = `From: <abusedesk@example.com>
+Date: Thu, 8 Mar 2005 17:40:36 EDT
+Subject: FW: Discount on Viagra
+To: <abuse@example.net>
+Message-ID: <20030712040037.46341.5F8J@example.com>
+MIME-Version: 1.0
+Content-Type: multipart/report; report-type=feedback-report;
+     boundary="part1_13d.2e68ed54_boundary"
+
+--part1_13d.2e68ed54_boundary
+Content-Type: text/plain; charset="US-ASCII"
+Content-Transfer-Encoding: 7bit
+
+This is an email abuse report for an email message received from IP
+192.0.2.1 on Thu, 8 Mar 2005 14:00:00 EDT.
+
+--part1_13d.2e68ed54_boundary
+Content-Type: message/feedback-report
+
+Feedback-Type: abuse
+User-Agent: SomeGenerator/1.0
+Version: 1
+Original-Mail-From: <somespammer@example.net>
+Original-Rcpt-To: <user@example.com>
+Arrival-Date: Thu, 8 Mar 2005 14:00:00 -0400
+Reporting-MTA: dns; mail.example.com
+Source-IP: 192.0.2.1
+Authentication-Results: mail.example.com;
+               spf=fail smtp.mailfrom=somespammer@example.com
+Reported-Domain: example.net
+Reported-Uri: http://example.net/earn_money.html
+Reported-Uri: mailto:user@example.com
+Removal-Recipient: user@example.com
+
+--part1_13d.2e68ed54_boundary
+Content-Type: message/rfc822
+Content-Disposition: inline
+
+From: <somespammer@example.net>
+Received: from mailserver.example.net (mailserver.example.net
+        [192.0.2.1]) by example.com with ESMTP id M63d4137594e46;
+        Thu, 08 Mar 2005 14:00:00 -0400
+To: <Undisclosed Recipients>
+Subject: Earn money
+MIME-Version: 1.0
+Content-type: text/plain
+Message-ID: 8787KJKJ3K4J3K4J3K4J3.mail@example.net
+Date: Thu, 02 Sep 2004 12:31:03 -0500
+
+Spam Spam Spam
+Spam Spam Spam
+Spam Spam Spam
+Spam Spam Spam
+--part1_13d.2e68ed54_boundary--
+`
+
+func TestParse(t *testing.T) {
+	msg := strings.ReplaceAll(reportMessage, "\n", "\r\n")
+	report, err := Parse(nil, strings.NewReader(msg))
+	if err != nil {
+		t.Fatalf("parsing report: %v", err)
+	}
+	expArrival := time.Date(2005, 3, 8, 14, 0, 0, 0, time.FixedZone("", -4*3600))
+	if !report.ArrivalDate.Equal(expArrival) {
+		t.Fatalf("arrival date: got %v, expected %v", report.ArrivalDate, expArrival)
+	}
+	report.ArrivalDate = time.Time{}
+	exp := Report{
+		FeedbackType:          FeedbackAbuse,
+		UserAgent:             "SomeGenerator/1.0",
+		Version:               "1",
+		OriginalMailFrom:      "somespammer@example.net",
+		OriginalRcptTo:        []string{"user@example.com"},
+		ReportingMTA:          "mail.example.com",
+		SourceIP:              "192.0.2.1",
+		Incidents:             1,
+		AuthenticationResults: []string{"mail.example.com; spf=fail smtp.mailfrom=somespammer@example.com"},
+		ReportedDomain:        []string{"example.net"},
+		ReportedURI:           []string{"http://example.net/earn_money.html", "mailto:user@example.com"},
+		Text:                  "This is an email abuse report for an email message received from IP\n192.0.2.1 on Thu, 8 Mar 2005 14:00:00 EDT.\n",
+		OriginalMessageID:     "8787KJKJ3K4J3K4J3K4J3.mail@example.net",
+		OriginalFrom:          "<somespammer@example.net>",
+		OriginalTo:            "<Undisclosed Recipients>",
+		OriginalSubject:       "Earn money",
+	}
+	if !reflect.DeepEqual(*report, exp) {
+		t.Fatalf("report:\ngot      %#v\nexpected %#v", *report, exp)
+	}
+
+	// Not a feedback report.
+	_, err = Parse(nil, strings.NewReader(strings.Replace(msg, "report-type=feedback-report", "report-type=delivery-status", 1)))
+	if !errors.Is(err, ErrNoReport) {
+		t.Fatalf("parsing delivery-status report: got err %v, expected ErrNoReport", err)
+	}
+
+	// Missing required field.
+	_, err = Parse(nil, strings.NewReader(strings.Replace(msg, "Feedback-Type: abuse\r\n", "", 1)))
+	if err == nil {
+		t.Fatalf("parsing report without feedback-type: got no error")
+	}
+
+	// Original message is optional.
+	noOrig := msg[:strings.Index(msg, "--part1_13d.2e68ed54_boundary\r\nContent-Type: message/rfc822")] + "--part1_13d.2e68ed54_boundary--\r\n"
+	report, err = Parse(nil, strings.NewReader(noOrig))
+	if err != nil {
+		t.Fatalf("parsing report without original message: %v", err)
+	}
+	if report.FeedbackType != FeedbackAbuse || report.OriginalMessageID != "" {
+		t.Fatalf("report without original message: got %#v", report)
+	}
+}
+
+func TestFields(t *testing.T) {
+	r := Report{
+		FeedbackType:          FeedbackAuthFailure,
+		UserAgent:             "mox/dev",
+		Version:               "1",
+		AuthFailure:           "dmarc",
+		OriginalMailFrom:      "sender@example.org",
+		ArrivalDate:           time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		ReportingMTA:          "mail.example.com",
+		SourceIP:              "192.0.2.1",
+		Incidents:             1,
+		DeliveryResult:        "reject",
+		AuthenticationResults: []string{"mail.example.com; dmarc=fail header.from=example.org"},
+		IdentityAlignment:     "none",
+		DKIMDomain:            "example.org",
+		DKIMSelector:          "sel",
+		ReportedDomain:        []string{"example.org"},
+	}
+	fields := r.Fields()
+	if !strings.HasPrefix(fields, "Feedback-Type: auth-failure\r\n") || !strings.Contains(fields, "Original-Mail-From: <sender@example.org>\r\n") || strings.Contains(fields, "Incidents:") {
+		t.Fatalf("unexpected fields:\n%s", fields)
+	}
+	nr, err := Decode(strings.NewReader(fields))
+	if err != nil {
+		t.Fatalf("decoding fields: %v", err)
+	}
+	if !nr.ArrivalDate.Equal(r.ArrivalDate) {
+		t.Fatalf("arrival date: got %v, expected %v", nr.ArrivalDate, r.ArrivalDate)
+	}
+	nr.ArrivalDate = r.ArrivalDate
+	if !reflect.DeepEqual(*nr, r) {
+		t.Fatalf("decoded fields:\ngot      %#v\nexpected %#v", *nr, r)
+	}
+}
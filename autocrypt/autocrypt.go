@@ -0,0 +1,88 @@
+// Package autocrypt parses and writes Autocrypt message headers, used to
+// exchange OpenPGP public keys in regular email messages between mail user
+// agents, see https://autocrypt.org/level1.html.
+package autocrypt
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrSyntax = errors.New("autocrypt header syntax error")
+
+// Header is an Autocrypt header. The key is the binary OpenPGP transferable public
+// key of the sender.
+type Header struct {
+	Addr          string // Email address of the sender, must match the From header.
+	PreferEncrypt bool   // For "prefer-encrypt=mutual".
+	KeyData       []byte
+}
+
+// Parse parses the value of an Autocrypt header.
+//
+// Unknown attributes starting with an underscore are ignored. Other unknown
+// attributes make the header invalid, as required by the specification.
+func Parse(s string) (Header, error) {
+	var h Header
+	var haveKey bool
+	for _, t := range strings.Split(s, ";") {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(t, "=")
+		if !ok {
+			return Header{}, fmt.Errorf("%w: missing value for attribute %q", ErrSyntax, t)
+		}
+		k = strings.ToLower(strings.TrimSpace(k))
+		v = strings.TrimSpace(v)
+		switch k {
+		case "addr":
+			h.Addr = v
+		case "prefer-encrypt":
+			h.PreferEncrypt = v == "mutual"
+		case "keydata":
+			buf, err := base64.StdEncoding.DecodeString(strings.Map(func(c rune) rune {
+				if c == ' ' || c == '\t' || c == '\r' || c == '\n' {
+					return -1
+				}
+				return c
+			}, v))
+			if err != nil {
+				return Header{}, fmt.Errorf("%w: decoding keydata: %v", ErrSyntax, err)
+			}
+			h.KeyData = buf
+			haveKey = true
+		default:
+			if !strings.HasPrefix(k, "_") {
+				return Header{}, fmt.Errorf("%w: unknown critical attribute %q", ErrSyntax, k)
+			}
+		}
+	}
+	if h.Addr == "" {
+		return Header{}, fmt.Errorf("%w: missing addr", ErrSyntax)
+	}
+	if !haveKey || len(h.KeyData) == 0 {
+		return Header{}, fmt.Errorf("%w: missing keydata", ErrSyntax)
+	}
+	return h, nil
+}
+
+// String returns the header value, with the key data folded over multiple lines.
+func (h Header) String() string {
+	var b strings.Builder
+	b.WriteString("addr=" + h.Addr + ";")
+	if h.PreferEncrypt {
+		b.WriteString(" prefer-encrypt=mutual;")
+	}
+	b.WriteString(" keydata=")
+	s := base64.StdEncoding.EncodeToString(h.KeyData)
+	for len(s) > 0 {
+		n := min(len(s), 76)
+		b.WriteString("\r\n\t" + s[:n])
+		s = s[n:]
+	}
+	return b.String()
+}
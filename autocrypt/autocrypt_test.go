@@ -0,0 +1,100 @@
+package autocrypt
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAutocrypt(t *testing.T) {
+	h := Header{Addr: "mjl@mox.example", PreferEncrypt: true, KeyData: []byte(strings.Repeat("key data ", 20))}
+	s := h.String()
+	if !strings.HasPrefix(s, "addr=mjl@mox.example; prefer-encrypt=mutual; keydata=\r\n\t") {
+		t.Fatalf("unexpected header %q", s)
+	}
+	for _, line := range strings.Split(s, "\r\n") {
+		if len(line) > 78 {
+			t.Fatalf("line too long: %q", line)
+		}
+	}
+	nh, err := Parse(s)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if !reflect.DeepEqual(nh, h) {
+		t.Fatalf("got %#v, expected %#v", nh, h)
+	}
+
+	nh, err = Parse("addr=mjl@mox.example; _unknown=x; keydata=AAEC")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if nh.PreferEncrypt || !reflect.DeepEqual(nh.KeyData, []byte{0, 1, 2}) {
+		t.Fatalf("unexpected header %#v", nh)
+	}
+
+	bad := []string{
+		"",
+		"addr=mjl@mox.example",             // No keydata.
+		"keydata=AAEC",                     // No addr.
+		"addr=mjl@mox.example; keydata=!!", // Bad base64.
+		"addr=mjl@mox.example; x=y; keydata=AAEC", // Unknown critical attribute.
+		"addr=mjl@mox.example; keydata",           // No value.
+	}
+	for _, s := range bad {
+		if _, err := Parse(s); !errors.Is(err, ErrSyntax) {
+			t.Fatalf("parse %q: got err %v, expected ErrSyntax", s, err)
+		}
+	}
+}
+
+func TestKey(t *testing.T) {
+	// Version 4 EdDSA public key packet, with bogus key material.
+	// Version 4, creation time, EdDSA, curve OID for Ed25519, 7-bit point.
+	pk := []byte{4, 0x5f, 0, 0, 0, 22, 9, 0x2b, 0x06, 0x01, 0x04, 0x01, 0xda, 0x47, 0x0f, 0x01, 0, 7, 0x40}
+	uid := []byte("Mox User <MJL@mox.example>")
+	var buf []byte
+	buf = append(buf, 0xc0|6, byte(len(pk)))
+	buf = append(buf, pk...)
+	buf = append(buf, 0x80|13<<2, byte(len(uid))) // Old format packet.
+	buf = append(buf, uid...)
+
+	k, err := ParseKey(buf)
+	if err != nil {
+		t.Fatalf("parse key: %v", err)
+	}
+	h := sha1.New()
+	h.Write([]byte{0x99, 0, byte(len(pk))})
+	h.Write(pk)
+	if exp := fmt.Sprintf("%X", h.Sum(nil)); k.Fingerprint != exp || k.Secret {
+		t.Fatalf("got fingerprint %s, secret %v, expected %s", k.Fingerprint, k.Secret, exp)
+	}
+	if !reflect.DeepEqual(k.Addresses(), []string{"mjl@mox.example"}) {
+		t.Fatalf("got addresses %v", k.Addresses())
+	}
+
+	if _, err := ParseKey(buf[:len(buf)-1]); !errors.Is(err, ErrKey) {
+		t.Fatalf("parse truncated key, got err %v, expected ErrKey", err)
+	}
+	if _, err := ParseKey(append([]byte{0xc0 | 13, 1, 'x'}, buf...)); !errors.Is(err, ErrKey) {
+		t.Fatalf("parse key starting with user id, got err %v, expected ErrKey", err)
+	}
+
+	armored := Armor("PGP PUBLIC KEY BLOCK", buf)
+	typ, data, err := Unarmor("text before\n" + strings.Replace(armored, "\r\n\r\n", "\r\nComment: test\r\n\r\n", 1))
+	if err != nil || typ != "PGP PUBLIC KEY BLOCK" || !reflect.DeepEqual(data, buf) {
+		t.Fatalf("unarmor: got %q %x %v", typ, data, err)
+	}
+	bad := strings.Replace(armored, "\r\n=", "\r\n=AAAA", 1)
+	if _, _, err := Unarmor(bad); !errors.Is(err, ErrKey) {
+		t.Fatalf("unarmor with bad checksum, got err %v, expected ErrKey", err)
+	}
+
+	// Example from draft-koch-openpgp-webkey-service.
+	if s := WKDHash("Joe.Doe"); s != "iy9q119eutrkn8s1mk4r39qejnbu3n5q" {
+		t.Fatalf("wkd hash, got %q", s)
+	}
+}
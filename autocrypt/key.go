@@ -0,0 +1,279 @@
+package autocrypt
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrKey is returned for OpenPGP keys that cannot be parsed.
+var ErrKey = errors.New("invalid openpgp key")
+
+// Key holds the properties of an OpenPGP transferable key, as far as we need them
+// for key management. Only version 4 keys are supported. Key material and
+// signatures are not verified.
+type Key struct {
+	Fingerprint string   // Of the primary key, upper case hex.
+	UserIDs     []string // E.g. "Mox User <mjl@mox.example>".
+	Secret      bool     // Whether this is a secret key (with encrypted or unencrypted key material).
+}
+
+// Addresses returns the email addresses from the user IDs, lower-cased.
+func (k Key) Addresses() []string {
+	var l []string
+	for _, s := range k.UserIDs {
+		if i := strings.LastIndexByte(s, '<'); i >= 0 && strings.HasSuffix(s, ">") {
+			s = s[i+1 : len(s)-1]
+		}
+		if strings.Contains(s, "@") {
+			l = append(l, strings.ToLower(strings.TrimSpace(s)))
+		}
+	}
+	return l
+}
+
+// ParseKey parses a binary OpenPGP transferable public or secret key, RFC 4880
+// section 11.1 and 11.2.
+func ParseKey(buf []byte) (Key, error) {
+	var k Key
+	first := true
+	for len(buf) > 0 {
+		tag, body, rest, err := readPacket(buf)
+		if err != nil {
+			return Key{}, err
+		}
+		buf = rest
+		if first {
+			if tag != 5 && tag != 6 {
+				return Key{}, fmt.Errorf("%w: first packet is tag %d, expected public or secret key", ErrKey, tag)
+			}
+			k.Secret = tag == 5
+			n, err := publicKeyLength(body)
+			if err != nil {
+				return Key{}, err
+			}
+			h := sha1.New()
+			h.Write([]byte{0x99, byte(n >> 8), byte(n)})
+			h.Write(body[:n])
+			k.Fingerprint = fmt.Sprintf("%X", h.Sum(nil))
+			first = false
+		} else if tag == 13 {
+			k.UserIDs = append(k.UserIDs, string(body))
+		}
+	}
+	if first {
+		return Key{}, fmt.Errorf("%w: no packets", ErrKey)
+	}
+	return k, nil
+}
+
+// readPacket reads a packet in old or new format, RFC 4880 section 4.2. Partial
+// body lengths are not allowed in keys.
+func readPacket(buf []byte) (tag int, body, rest []byte, rerr error) {
+	if len(buf) < 2 || buf[0]&0x80 == 0 {
+		return 0, nil, nil, fmt.Errorf("%w: bad packet header", ErrKey)
+	}
+	var n, hlen int
+	if buf[0]&0x40 == 0 {
+		// Old format.
+		tag = int(buf[0]>>2) & 0xf
+		switch buf[0] & 3 {
+		case 0:
+			n, hlen = int(buf[1]), 2
+		case 1:
+			if len(buf) < 3 {
+				return 0, nil, nil, fmt.Errorf("%w: short packet header", ErrKey)
+			}
+			n, hlen = int(binary.BigEndian.Uint16(buf[1:])), 3
+		case 2:
+			if len(buf) < 5 {
+				return 0, nil, nil, fmt.Errorf("%w: short packet header", ErrKey)
+			}
+			n, hlen = int(binary.BigEndian.Uint32(buf[1:])), 5
+		default:
+			return 0, nil, nil, fmt.Errorf("%w: indeterminate packet length", ErrKey)
+		}
+	} else {
+		tag = int(buf[0] & 0x3f)
+		switch c := int(buf[1]); {
+		case c < 192:
+			n, hlen = c, 2
+		case c < 224:
+			if len(buf) < 3 {
+				return 0, nil, nil, fmt.Errorf("%w: short packet header", ErrKey)
+			}
+			n, hlen = (c-192)<<8+int(buf[2])+192, 3
+		case c == 255:
+			if len(buf) < 6 {
+				return 0, nil, nil, fmt.Errorf("%w: short packet header", ErrKey)
+			}
+			n, hlen = int(binary.BigEndian.Uint32(buf[2:])), 6
+		default:
+			return 0, nil, nil, fmt.Errorf("%w: partial body length", ErrKey)
+		}
+	}
+	if n < 0 || len(buf)-hlen < n {
+		return 0, nil, nil, fmt.Errorf("%w: packet length beyond end of data", ErrKey)
+	}
+	return tag, buf[hlen : hlen+n], buf[hlen+n:], nil
+}
+
+// publicKeyLength returns the length of the public key fields at the start of a
+// version 4 public or secret key packet, RFC 4880 section 5.5.2 and RFC 6637.
+func publicKeyLength(body []byte) (int, error) {
+	if len(body) < 6 || body[0] != 4 {
+		return 0, fmt.Errorf("%w: only version 4 keys are supported", ErrKey)
+	}
+	o := 6
+	mpi := func() error {
+		if len(body)-o < 2 {
+			return fmt.Errorf("%w: short mpi", ErrKey)
+		}
+		bits := int(binary.BigEndian.Uint16(body[o:]))
+		o += 2 + (bits+7)/8
+		if o > len(body) {
+			return fmt.Errorf("%w: short mpi", ErrKey)
+		}
+		return nil
+	}
+	// Length-prefixed field, for curve OIDs and KDF parameters.
+	field := func() error {
+		if o >= len(body) || o+1+int(body[o]) > len(body) {
+			return fmt.Errorf("%w: short field", ErrKey)
+		}
+		o += 1 + int(body[o])
+		return nil
+	}
+	var fields []func() error
+	switch algo := body[5]; algo {
+	case 1, 2, 3: // RSA: n, e.
+		fields = []func() error{mpi, mpi}
+	case 16: // Elgamal: p, g, y.
+		fields = []func() error{mpi, mpi, mpi}
+	case 17: // DSA: p, q, g, y.
+		fields = []func() error{mpi, mpi, mpi, mpi}
+	case 18: // ECDH: curve, point, kdf parameters.
+		fields = []func() error{field, mpi, field}
+	case 19, 22: // ECDSA, EdDSA: curve, point.
+		fields = []func() error{field, mpi}
+	default:
+		return 0, fmt.Errorf("%w: unknown public key algorithm %d", ErrKey, algo)
+	}
+	for _, f := range fields {
+		if err := f(); err != nil {
+			return 0, err
+		}
+	}
+	return o, nil
+}
+
+// Armor returns the ASCII armored form of binary OpenPGP data, RFC 4880 section
+// 6.2. Typ is e.g. "PGP PUBLIC KEY BLOCK" or "PGP MESSAGE".
+func Armor(typ string, data []byte) string {
+	var b strings.Builder
+	b.WriteString("-----BEGIN " + typ + "-----\r\n\r\n")
+	s := base64.StdEncoding.EncodeToString(data)
+	for len(s) > 0 {
+		n := min(len(s), 64)
+		b.WriteString(s[:n] + "\r\n")
+		s = s[n:]
+	}
+	crc := crc24(data)
+	b.WriteString("=" + base64.StdEncoding.EncodeToString([]byte{byte(crc >> 16), byte(crc >> 8), byte(crc)}) + "\r\n")
+	b.WriteString("-----END " + typ + "-----\r\n")
+	return b.String()
+}
+
+// Unarmor parses ASCII armored data, returning the armor type, e.g. "PGP PUBLIC
+// KEY BLOCK", and the binary data. The checksum is verified if present.
+func Unarmor(s string) (typ string, data []byte, rerr error) {
+	lines := strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+	for len(lines) > 0 && !strings.HasPrefix(lines[0], "-----BEGIN ") {
+		lines = lines[1:]
+	}
+	if len(lines) == 0 || !strings.HasSuffix(strings.TrimSpace(lines[0]), "-----") {
+		return "", nil, fmt.Errorf("%w: missing armor header line", ErrKey)
+	}
+	typ = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(lines[0]), "-----BEGIN "), "-----")
+	lines = lines[1:]
+	// Skip armor headers, until the empty line.
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) != "" {
+		if !strings.Contains(lines[0], ":") {
+			// No headers and no empty line, tolerated.
+			break
+		}
+		lines = lines[1:]
+	}
+	var b64, checksum string
+	end := false
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "-----END "+typ+"-----" {
+			end = true
+			break
+		} else if strings.HasPrefix(line, "=") {
+			checksum = line[1:]
+		} else {
+			b64 += line
+		}
+	}
+	if !end {
+		return "", nil, fmt.Errorf("%w: missing armor tail line", ErrKey)
+	}
+	data, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: decoding base64: %v", ErrKey, err)
+	}
+	if checksum != "" {
+		buf, err := base64.StdEncoding.DecodeString(checksum)
+		if err != nil || len(buf) != 3 {
+			return "", nil, fmt.Errorf("%w: bad armor checksum", ErrKey)
+		}
+		crc := crc24(data)
+		if !bytes.Equal(buf, []byte{byte(crc >> 16), byte(crc >> 8), byte(crc)}) {
+			return "", nil, fmt.Errorf("%w: armor checksum mismatch", ErrKey)
+		}
+	}
+	return typ, data, nil
+}
+
+// crc24 is the checksum for armored data, RFC 4880 section 6.1.
+func crc24(data []byte) uint32 {
+	crc := uint32(0xb704ce)
+	for _, c := range data {
+		crc ^= uint32(c) << 16
+		for i := 0; i < 8; i++ {
+			crc <<= 1
+			if crc&0x1000000 != 0 {
+				crc ^= 0x1864cfb
+			}
+		}
+	}
+	return crc & 0xffffff
+}
+
+// WKDHash returns the hashed localpart for use in Web Key Directory URLs: the
+// z-base-32 encoded SHA-1 hash of the lower-cased localpart,
+// draft-koch-openpgp-webkey-service section 3.1.
+func WKDHash(localpart string) string {
+	const alphabet = "ybndrfg8ejkmcpqxot1uwisza345h769"
+	sum := sha1.Sum([]byte(strings.ToLower(localpart)))
+	var b strings.Builder
+	var bits, n uint
+	for _, c := range sum {
+		bits = bits<<8 | uint(c)
+		n += 8
+		for n >= 5 {
+			n -= 5
+			b.WriteByte(alphabet[(bits>>n)&0x1f])
+		}
+	}
+	if n > 0 {
+		b.WriteByte(alphabet[(bits<<(5-n))&0x1f])
+	}
+	return b.String()
+}
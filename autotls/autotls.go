@@ -2,9 +2,10 @@
 // requesting certificates with ACME, typically from Let's Encrypt.
 package autotls
 
-// We do tls-alpn-01, and also http-01. For DNS we would need a third party tool
-// with an API that can make the DNS changes, as we don't want to link in dozens of
-// bespoke API's for DNS record manipulation into mox.
+// We do tls-alpn-01, and also http-01. For dns-01, DNS changes are made with
+// dynamic DNS updates (RFC 2136), or with an external command that can call the
+// API of a DNS provider, as we don't want to link in dozens of bespoke API's for
+// DNS record manipulation into mox.
 
 import (
 	"bytes"
@@ -24,6 +25,7 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
@@ -48,8 +50,21 @@ var (
 			Help: "Number of certificate store puts.",
 		},
 	)
+	metricFallback = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mox_autotls_fallback_total",
+			Help: "Certificates requested from a fallback ACME provider after a failed request with the primary provider.",
+		},
+		[]string{
+			"provider", // Name of fallback ACME provider.
+		},
+	)
 )
 
+// After a failed certificate request, certificates for the host are requested from
+// fallback providers for this period, before trying the primary provider again.
+const fallbackPeriod = 6 * time.Hour
+
 // Manager is in charge of a single ACME identity, and automatically requests
 // certificates for allowlisted hosts.
 type Manager struct {
@@ -57,10 +72,20 @@ type Manager struct {
 	TLSConfig     *tls.Config // For all TLS servers not used for validating ACME requests. Like SMTP and IMAP (including with STARTTLS) and HTTPS on ports other than 443.
 	Manager       *autocert.Manager
 
+	name     string
 	shutdown <-chan struct{}
 
 	sync.Mutex
-	hosts map[dns.Domain]struct{}
+	hosts          map[dns.Domain]struct{}
+	dns01          *DNS01
+	dns01Certs     map[string]*tls.Certificate // By name from dns01, nil until obtained.
+	fallbacks      []*Manager                  // Tried in order when requesting a certificate fails.
+	primaries      []*Manager                  // Managers we are a fallback for, their hosts are allowed too.
+	routes         map[string]*Manager         // Host to manager that provides its certificates instead.
+	failed         map[string]time.Time        // Host to end of period in which fallbacks are used.
+	ariCerts       map[string]*tls.Certificate // By host, renewed early after suggestion from renewal info.
+	renewalWindows map[string]renewalWindow    // By host or dns01 name.
+	renewalInfoURL *string                     // From directory, empty if not supported. Nil until fetched.
 }
 
 // Load returns an initialized autotls manager for "name" (used for the ACME key
@@ -158,84 +183,326 @@ func Load(name, acmeDir, contactEmail, directoryURL string, eabKeyID string, eab
 		}
 	}
 
-	loggingGetCertificate := func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
-		log := mlog.New("autotls", nil).WithContext(hello.Context())
+	a := &Manager{
+		Manager:        m,
+		name:           name,
+		shutdown:       shutdown,
+		hosts:          map[dns.Domain]struct{}{},
+		failed:         map[string]time.Time{},
+		ariCerts:       map[string]*tls.Certificate{},
+		renewalWindows: map[string]renewalWindow{},
+	}
+
+	acmeTLSConfig := *m.TLSConfig()
+	acmeTLSConfig.GetCertificate = a.GetCertificate
 
-		// We handle missing invalid hostnames/ip's by returning a nil certificate and nil
-		// error, which crypto/tls turns into a TLS alert "unrecognized name", which can be
-		// interpreted by clients as a hint that they are using the wrong hostname, or a
-		// certificate is missing.
+	tlsConfig := tls.Config{
+		GetCertificate: a.GetCertificate,
+	}
 
-		// Handle missing SNI to prevent logging an error below.
-		// At startup, during config initialization, we already adjust the tls config to
-		// inject the listener hostname if there isn't one in the TLS client hello. This is
-		// common for SMTP STARTTLS connections, which often do not care about the
-		// verification of the certificate.
-		if hello.ServerName == "" {
-			log.Debug("tls request without sni servername, rejecting", slog.Any("localaddr", hello.Conn.LocalAddr()), slog.Any("supportedprotos", hello.SupportedProtos))
-			return nil, nil
+	a.ACMETLSConfig = &acmeTLSConfig
+	a.TLSConfig = &tlsConfig
+	m.HostPolicy = a.HostPolicy
+	return a, nil
+}
+
+// GetCertificate returns a certificate for the host in the TLS client hello,
+// requesting a new certificate if needed. Certificates for hosts routed to another
+// manager are returned by that manager. If requesting a certificate fails,
+// fallback managers are tried.
+func (m *Manager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	ctx := hello.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	log := mlog.New("autotls", nil).WithContext(ctx)
+
+	// We handle missing invalid hostnames/ip's by returning a nil certificate and nil
+	// error, which crypto/tls turns into a TLS alert "unrecognized name", which can be
+	// interpreted by clients as a hint that they are using the wrong hostname, or a
+	// certificate is missing.
+
+	// Handle missing SNI to prevent logging an error below.
+	// At startup, during config initialization, we already adjust the tls config to
+	// inject the listener hostname if there isn't one in the TLS client hello. This is
+	// common for SMTP STARTTLS connections, which often do not care about the
+	// verification of the certificate.
+	if hello.ServerName == "" {
+		var localAddr net.Addr
+		if hello.Conn != nil {
+			localAddr = hello.Conn.LocalAddr()
 		}
+		log.Debug("tls request without sni servername, rejecting", slog.Any("localaddr", localAddr), slog.Any("supportedprotos", hello.SupportedProtos))
+		return nil, nil
+	}
 
-		cert, err := m.GetCertificate(hello)
-		if err != nil && errors.Is(err, errHostNotAllowed) {
-			log.Debugx("requesting certificate", err, slog.String("host", hello.ServerName))
-			return nil, nil
-		} else if err != nil {
-			log.Errorx("requesting certificate", err, slog.String("host", hello.ServerName))
+	host := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	m.Lock()
+	route := m.routes[host]
+	fallbacks := m.fallbacks
+	fallbackUntil := m.failed[host]
+	ariCert := m.ariCerts[host]
+	m.Unlock()
+	if route != nil && route != m {
+		return route.GetCertificate(hello)
+	}
+
+	// Challenge from the ACME provider, for a request by us or a fallback.
+	if len(hello.SupportedProtos) == 1 && hello.SupportedProtos[0] == acme.ALPNProto {
+		cert, err := m.Manager.GetCertificate(hello)
+		for _, fb := range fallbacks {
+			if err == nil {
+				break
+			}
+			cert, err = fb.Manager.GetCertificate(hello)
 		}
 		return cert, err
 	}
 
-	acmeTLSConfig := *m.TLSConfig()
-	acmeTLSConfig.GetCertificate = loggingGetCertificate
+	if cert, covered := m.dns01Certificate(host); covered {
+		if cert == nil {
+			log.Debug("no dns-01 certificate available yet", slog.String("host", host))
+		}
+		return cert, nil
+	}
 
-	tlsConfig := tls.Config{
-		GetCertificate: loggingGetCertificate,
+	var cert *tls.Certificate
+	var err error
+	if time.Now().Before(fallbackUntil) {
+		err = fmt.Errorf("recent certificate request with acme provider %s failed", m.name)
+	} else {
+		cert, err = m.Manager.GetCertificate(hello)
+		if err != nil && errors.Is(err, errHostNotAllowed) {
+			log.Debugx("requesting certificate", err, slog.String("host", host))
+			return nil, nil
+		} else if err == nil {
+			// Use certificate from early renewal until autocert picks it up from the cache.
+			if _, ok := cert.PrivateKey.(*ecdsa.PrivateKey); ok && ariCert != nil && cert.Leaf != nil && ariCert.Leaf.NotAfter.After(cert.Leaf.NotAfter) {
+				return ariCert, nil
+			}
+			return cert, nil
+		}
+		log.Errorx("requesting certificate", err, slog.String("host", host), slog.String("provider", m.name))
+		if len(fallbacks) == 0 {
+			return nil, err
+		}
+		m.Lock()
+		m.failed[host] = time.Now().Add(fallbackPeriod)
+		m.Unlock()
 	}
 
-	a := &Manager{
-		ACMETLSConfig: &acmeTLSConfig,
-		TLSConfig:     &tlsConfig,
-		Manager:       m,
-		shutdown:      shutdown,
-		hosts:         map[dns.Domain]struct{}{},
+	for _, fb := range fallbacks {
+		fcert, ferr := fb.Manager.GetCertificate(hello)
+		if ferr == nil {
+			if !time.Now().Before(fallbackUntil) {
+				metricFallback.WithLabelValues(fb.name).Inc()
+				log.Info("using certificate from fallback acme provider", slog.String("host", host), slog.String("provider", fb.name))
+			}
+			return fcert, nil
+		}
+		log.Errorx("requesting certificate from fallback acme provider", ferr, slog.String("host", host), slog.String("provider", fb.name))
+		err = ferr
+	}
+	return nil, err
+}
+
+// SetFallbacks sets the managers to request certificates from, in order, when a
+// certificate request fails. Hosts allowed for m are also allowed for the
+// fallbacks.
+func (m *Manager) SetFallbacks(fallbacks []*Manager) {
+	m.Lock()
+	m.fallbacks = fallbacks
+	m.Unlock()
+	for _, fb := range fallbacks {
+		fb.Lock()
+		if !slices.Contains(fb.primaries, m) {
+			fb.primaries = append(fb.primaries, m)
+		}
+		fb.Unlock()
 	}
-	m.HostPolicy = a.HostPolicy
-	return a, nil
+}
+
+// SetRoutes sets the hosts (in ASCII) for which another manager provides the
+// certificates, e.g. to use a specific ACME provider for some hosts. The hosts
+// must be allowed at the other manager.
+func (m *Manager) SetRoutes(routes map[string]*Manager) {
+	m.Lock()
+	defer m.Unlock()
+	m.routes = routes
 }
 
 // CertAvailable checks whether a non-expired ECDSA certificate is available in the
 // cache for host. No other checks than expiration are done.
 func (m *Manager) CertAvailable(ctx context.Context, log mlog.Log, host dns.Domain) (bool, error) {
+	cert, err := m.cachedCert(ctx, host)
+	if err != nil || cert == nil {
+		return false, err
+	}
+	// We assume the certificate has a matching hostname, and is properly CA-signed. We
+	// only check the expiration time.
+	if time.Until(cert.NotBefore) > 0 || time.Since(cert.NotAfter) > 0 {
+		return false, nil
+	}
+	return true, nil
+}
+
+// CertExpiration returns the expiration time of the ECDSA certificate for host in
+// the cache. If no certificate is present, the zero time is returned.
+func (m *Manager) CertExpiration(ctx context.Context, host dns.Domain) (time.Time, error) {
+	cert, err := m.cachedCert(ctx, host)
+	if err != nil || cert == nil {
+		return time.Time{}, err
+	}
+	return cert.NotAfter, nil
+}
+
+// CertificateInfo describes a certificate obtained through ACME.
+type CertificateInfo struct {
+	Host               string // Hostname, or name (possibly a wildcard) for DNS-01 certificates.
+	Provider           string // Name of the ACME provider the certificate was requested from.
+	IssuerOrganization string
+	IssuerCommonName   string
+	SerialNumber       string // In hexadecimal.
+	NotBefore          time.Time
+	NotAfter           time.Time
+	DNS01              bool      // Requested with DNS-01 challenge.
+	Active             bool      // Whether used for new connections. A certificate from a fallback provider is only used if the primary provider has no certificate.
+	RenewalStart       time.Time // Start of renewal window suggested by renewal info (ARI) of the ACME provider. Zero if unknown.
+	RenewalEnd         time.Time
+}
+
+// Certificates returns information about the certificates in the cache for the
+// allowed hosts, including certificates from fallback providers, and for DNS-01
+// names. Hosts without certificate are skipped.
+func (m *Manager) Certificates(ctx context.Context) ([]CertificateInfo, error) {
+	info := func(c *Manager, host string, leaf *x509.Certificate, dns01 bool) CertificateInfo {
+		ci := CertificateInfo{
+			Host:             host,
+			Provider:         c.name,
+			IssuerCommonName: leaf.Issuer.CommonName,
+			SerialNumber:     fmt.Sprintf("%x", leaf.SerialNumber),
+			NotBefore:        leaf.NotBefore,
+			NotAfter:         leaf.NotAfter,
+			DNS01:            dns01,
+		}
+		if len(leaf.Issuer.Organization) > 0 {
+			ci.IssuerOrganization = leaf.Issuer.Organization[0]
+		}
+		c.Lock()
+		if w, ok := c.renewalWindows[host]; ok {
+			if id, err := certID(leaf); err == nil && id == w.CertID {
+				ci.RenewalStart = w.Start
+				ci.RenewalEnd = w.End
+			}
+		}
+		c.Unlock()
+		return ci
+	}
+
+	m.Lock()
+	fallbacks := m.fallbacks
+	var names []string
+	if m.dns01 != nil {
+		names = m.dns01.Hostnames
+	}
+	m.Unlock()
+
+	var l []CertificateInfo
+	for _, name := range names {
+		data, err := m.Manager.Cache.Get(ctx, dns01CacheKey(name))
+		if err != nil && errors.Is(err, autocert.ErrCacheMiss) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("get certificate for %s from cache: %v", name, err)
+		}
+		cert, err := parseKeyCert(data)
+		if err != nil {
+			return nil, fmt.Errorf("parsing certificate for %s: %v", name, err)
+		}
+		ci := info(m, name, cert.Leaf, true)
+		ci.Active = true
+		l = append(l, ci)
+	}
+
+	hosts := m.Hostnames()
+	sort.Slice(hosts, func(i, j int) bool {
+		return hosts[i].Name() < hosts[j].Name()
+	})
+	for _, host := range hosts {
+		m.Lock()
+		covered := m.dns01Name(host.ASCII) != ""
+		m.Unlock()
+		if covered {
+			continue
+		}
+		var active bool
+		for _, c := range append([]*Manager{m}, fallbacks...) {
+			leaf, err := c.cachedCertOwn(ctx, host)
+			if err != nil {
+				return nil, fmt.Errorf("get certificate for %s: %v", host, err)
+			} else if leaf == nil {
+				continue
+			}
+			ci := info(c, host.ASCII, leaf, false)
+			ci.Active = !active && time.Now().Before(leaf.NotAfter)
+			active = active || ci.Active
+			l = append(l, ci)
+		}
+	}
+	return l, nil
+}
+
+// cachedCert returns the leaf certificate for host from the cache, or nil if
+// absent. If absent, the certificate from the first fallback with a certificate is
+// returned.
+func (m *Manager) cachedCert(ctx context.Context, host dns.Domain) (*x509.Certificate, error) {
+	cert, err := m.cachedCertOwn(ctx, host)
+	if err != nil || cert != nil {
+		return cert, err
+	}
+	m.Lock()
+	fallbacks := m.fallbacks
+	m.Unlock()
+	for _, fb := range fallbacks {
+		if cert, err := fb.cachedCertOwn(ctx, host); err != nil || cert != nil {
+			return cert, err
+		}
+	}
+	return nil, nil
+}
+
+// cachedCertOwn returns the leaf certificate for host from our cache, or nil if
+// absent.
+func (m *Manager) cachedCertOwn(ctx context.Context, host dns.Domain) (*x509.Certificate, error) {
 	ck := host.ASCII // Would be "+rsa" for rsa keys.
+	m.Lock()
+	if name := m.dns01Name(host.ASCII); name != "" {
+		ck = dns01CacheKey(name)
+	}
+	m.Unlock()
 	data, err := m.Manager.Cache.Get(ctx, ck)
 	if err != nil && errors.Is(err, autocert.ErrCacheMiss) {
-		return false, nil
+		return nil, nil
 	} else if err != nil {
-		return false, fmt.Errorf("attempt to get certificate from cache: %v", err)
+		return nil, fmt.Errorf("attempt to get certificate from cache: %v", err)
 	}
 
 	// The cached keycert is of the form: private key, leaf certificate, intermediate certificates...
 	privb, rem := pem.Decode(data)
 	if privb == nil {
-		return false, fmt.Errorf("missing private key in cached keycert file")
+		return nil, fmt.Errorf("missing private key in cached keycert file")
 	}
 	pubb, _ := pem.Decode(rem)
 	if pubb == nil {
-		return false, fmt.Errorf("missing certificate in cached keycert file")
+		return nil, fmt.Errorf("missing certificate in cached keycert file")
 	} else if pubb.Type != "CERTIFICATE" {
-		return false, fmt.Errorf("second pem block is %q, expected CERTIFICATE", pubb.Type)
+		return nil, fmt.Errorf("second pem block is %q, expected CERTIFICATE", pubb.Type)
 	}
 	cert, err := x509.ParseCertificate(pubb.Bytes)
 	if err != nil {
-		return false, fmt.Errorf("parsing certificate from cached keycert file: %v", err)
+		return nil, fmt.Errorf("parsing certificate from cached keycert file: %v", err)
 	}
-	// We assume the certificate has a matching hostname, and is properly CA-signed. We
-	// only check the expiration time.
-	if time.Until(cert.NotBefore) > 0 || time.Since(cert.NotAfter) > 0 {
-		return false, nil
-	}
-	return true, nil
+	return cert, nil
 }
 
 // SetAllowedHostnames sets a new list of allowed hostnames for automatic TLS.
@@ -259,7 +526,8 @@ func (m *Manager) SetAllowedHostnames(log mlog.Log, resolver dns.Resolver, hostn
 	log.Debug("autotls setting allowed hostnames", slog.Any("hostnames", l), slog.Any("publicips", publicIPs))
 	var added []dns.Domain
 	for h := range hostnames {
-		if _, ok := m.hosts[h]; !ok {
+		// Hosts with dns-01 certificates don't have to be reachable.
+		if _, ok := m.hosts[h]; !ok && m.dns01Name(h.ASCII) == "" {
 			added = append(added, h)
 		}
 	}
@@ -316,8 +584,9 @@ var errHostNotAllowed = errors.New("autotls: host not in allowlist")
 
 // HostPolicy decides if a host is allowed for use with ACME, i.e. whether a
 // certificate will be returned if present and/or will be requested if not yet
-// present. Only hosts added with SetAllowedHostnames are allowed. During shutdown,
-// no new connections are allowed.
+// present. Only hosts added with SetAllowedHostnames are allowed, to this manager
+// or a manager we are a fallback for. During shutdown, no new connections are
+// allowed.
 func (m *Manager) HostPolicy(ctx context.Context, host string) (rerr error) {
 	log := mlog.New("autotls", nil).WithContext(ctx)
 	defer func() {
@@ -343,8 +612,18 @@ func (m *Manager) HostPolicy(ctx context.Context, host string) (rerr error) {
 	}
 
 	m.Lock()
-	defer m.Unlock()
-	if _, ok := m.hosts[d]; !ok {
+	_, ok := m.hosts[d]
+	primaries := m.primaries
+	m.Unlock()
+	for _, p := range primaries {
+		if ok {
+			break
+		}
+		p.Lock()
+		_, ok = p.hosts[d]
+		p.Unlock()
+	}
+	if !ok {
 		return fmt.Errorf("%w: %q", errHostNotAllowed, d)
 	}
 	return nil
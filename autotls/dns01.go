@@ -0,0 +1,261 @@
+package autotls
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/autocert"
+
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/mlog"
+)
+
+var metricDNS01 = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "mox_autotls_dns01_request_total",
+		Help: "Certificate requests with DNS-01 challenges, by result.",
+	},
+	[]string{
+		"result", // ok, error
+	},
+)
+
+// DNSUpdater adds and removes the TXT records for DNS-01 challenges. Names are
+// fully qualified, in ASCII, without trailing dot, e.g.
+// _acme-challenge.example.com.
+type DNSUpdater interface {
+	AddTXT(ctx context.Context, log mlog.Log, name, value string) error
+	RemoveTXT(ctx context.Context, log mlog.Log, name, value string) error
+}
+
+// DNS01 configures requesting certificates with DNS-01 challenges, which is
+// required for wildcard certificates.
+type DNS01 struct {
+	// Names to request certificates for, a certificate per name. In ASCII, wildcards
+	// start with "*.".
+	Hostnames        []string
+	Updater          DNSUpdater
+	PropagationDelay time.Duration // Before asking the ACME provider to validate. Default 1m.
+	RenewBefore      time.Duration // Default 30 days.
+}
+
+// SetDNS01 configures certificates to request with DNS-01 challenges. Hosts
+// matching the names are served with these certificates, not through
+// TLS-ALPN-01/HTTP-01. Certificates are only requested after Start.
+func (m *Manager) SetDNS01(c DNS01) {
+	m.Lock()
+	defer m.Unlock()
+	m.dns01 = &c
+	m.dns01Certs = map[string]*tls.Certificate{}
+}
+
+// dns01Name returns the name for DNS-01 covering host, or an empty string.
+func (m *Manager) dns01Name(host string) string {
+	if m.dns01 == nil {
+		return ""
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, name := range m.dns01.Hostnames {
+		if name == host {
+			return name
+		}
+	}
+	// Wildcards only match a single label.
+	if _, rest, ok := strings.Cut(host, "."); ok {
+		for _, name := range m.dns01.Hostnames {
+			if name == "*."+rest {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// DNS01Host returns whether certificates for host are requested with DNS-01
+// challenges.
+func (m *Manager) DNS01Host(host dns.Domain) bool {
+	m.Lock()
+	defer m.Unlock()
+	return m.dns01Name(host.ASCII) != ""
+}
+
+// dns01Certificate returns the certificate for host, if it is covered by a
+// DNS-01 name. The certificate is nil if it hasn't been obtained yet.
+func (m *Manager) dns01Certificate(host string) (cert *tls.Certificate, covered bool) {
+	m.Lock()
+	defer m.Unlock()
+	name := m.dns01Name(host)
+	if name == "" {
+		return nil, false
+	}
+	return m.dns01Certs[name], true
+}
+
+// dns01CacheKey returns the key for the certificate in the cache. The "+dns01"
+// suffix prevents clashes with certificates requested by autocert, and "_" can't
+// be in a hostname.
+func dns01CacheKey(name string) string {
+	return strings.Replace(name, "*", "_wildcard", 1) + "+dns01"
+}
+
+// renewDNS01 makes sure certificates for all DNS-01 names are available and not
+// about to expire. It returns false if an error occurred.
+func (m *Manager) renewDNS01(log mlog.Log) bool {
+	m.Lock()
+	c := m.dns01
+	m.Unlock()
+	if c == nil {
+		return true
+	}
+
+	ok := true
+	for _, name := range c.Hostnames {
+		if err := m.dns01Ensure(log, c, name); err != nil {
+			metricDNS01.WithLabelValues("error").Inc()
+			log.Errorx("requesting certificate with dns-01 challenge", err, slog.String("name", name))
+			ok = false
+		}
+	}
+	return ok
+}
+
+// dns01Ensure makes sure a valid certificate for name is available, requesting a
+// new one if needed.
+func (m *Manager) dns01Ensure(log mlog.Log, c *DNS01, name string) error {
+	ctx, cancel := m.requestContext()
+	defer cancel()
+
+	renewBefore := c.RenewBefore
+	if renewBefore == 0 {
+		renewBefore = 30 * 24 * time.Hour
+	}
+
+	m.Lock()
+	cert := m.dns01Certs[name]
+	m.Unlock()
+	if cert == nil {
+		data, err := m.Manager.Cache.Get(ctx, dns01CacheKey(name))
+		if err == nil {
+			cert, err = parseKeyCert(data)
+			if err != nil {
+				log.Errorx("parsing cached dns-01 certificate, requesting new certificate", err, slog.String("name", name))
+			}
+		} else if !errors.Is(err, autocert.ErrCacheMiss) {
+			return fmt.Errorf("get certificate from cache: %v", err)
+		}
+	}
+	if cert != nil && time.Until(cert.Leaf.NotAfter) > renewBefore && !m.renewalSuggested(ctx, log, name, cert.Leaf) {
+		m.Lock()
+		m.dns01Certs[name] = cert
+		m.Unlock()
+		return nil
+	}
+
+	log.Info("requesting certificate with dns-01 challenge", slog.String("name", name))
+	data, err := m.dns01Request(ctx, log, c, name)
+	if err != nil {
+		return err
+	}
+	cert, err = parseKeyCert(data)
+	if err != nil {
+		return fmt.Errorf("parsing new certificate: %v", err)
+	}
+	if err := m.Manager.Cache.Put(ctx, dns01CacheKey(name), data); err != nil {
+		return fmt.Errorf("storing certificate in cache: %v", err)
+	}
+	metricDNS01.WithLabelValues("ok").Inc()
+	log.Info("new certificate with dns-01 challenge", slog.String("name", name), slog.Time("notafter", cert.Leaf.NotAfter))
+	m.Lock()
+	m.dns01Certs[name] = cert
+	delete(m.renewalWindows, name)
+	m.Unlock()
+	return nil
+}
+
+// parseKeyCert parses a private key and certificate chain in PEM, as stored in
+// the cache.
+func parseKeyCert(data []byte) (*tls.Certificate, error) {
+	cert, err := tls.X509KeyPair(data, data)
+	if err != nil {
+		return nil, err
+	}
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("parsing leaf certificate: %v", err)
+	}
+	return &cert, nil
+}
+
+// dns01Request requests a new certificate for name with a DNS-01 challenge,
+// returning the private key and certificate chain in PEM, in the format of the
+// autocert cache.
+func (m *Manager) dns01Request(ctx context.Context, log mlog.Log, c *DNS01, name string) ([]byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generating key: %v", err)
+	}
+	return m.request(ctx, log, name, key, func(ctx context.Context, authz *acme.Authorization) error {
+		return m.dns01Authorize(ctx, log, c, authz)
+	})
+}
+
+// dns01Authorize fulfills the dns-01 challenge of the authorization, adding
+// the TXT record and removing it afterwards.
+func (m *Manager) dns01Authorize(ctx context.Context, log mlog.Log, c *DNS01, authz *acme.Authorization) error {
+	client := m.Manager.Client
+
+	chal, err := findChallenge(authz, "dns-01")
+	if err != nil {
+		return err
+	}
+	value, err := client.DNS01ChallengeRecord(chal.Token)
+	if err != nil {
+		return fmt.Errorf("dns-01 challenge record value: %v", err)
+	}
+	// For wildcard names, the identifier value is without "*.".
+	recordName := "_acme-challenge." + strings.TrimPrefix(authz.Identifier.Value, "*.")
+
+	if err := c.Updater.AddTXT(ctx, log, recordName, value); err != nil {
+		return fmt.Errorf("adding txt record %s: %v", recordName, err)
+	}
+	defer func() {
+		// Context may be canceled, we still want to clean up.
+		rctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		err := c.Updater.RemoveTXT(rctx, log, recordName, value)
+		log.Check(err, "removing txt record for dns-01 challenge", slog.String("name", recordName))
+	}()
+
+	delay := c.PropagationDelay
+	if delay == 0 {
+		delay = time.Minute
+	}
+	log.Debug("waiting for dns propagation of txt record", slog.String("name", recordName), slog.Duration("delay", delay))
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(delay):
+	}
+
+	if _, err := client.Accept(ctx, chal); err != nil {
+		return fmt.Errorf("accepting challenge: %v", err)
+	}
+	if _, err := client.WaitAuthorization(ctx, authz.URI); err != nil {
+		return fmt.Errorf("waiting for authorization: %v", err)
+	}
+	return nil
+}
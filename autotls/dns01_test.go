@@ -0,0 +1,225 @@
+package autotls
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/mjl-/mox/mlog"
+)
+
+func tcheck(t *testing.T, err error, msg string) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %s", msg, err)
+	}
+}
+
+func TestDNS01Name(t *testing.T) {
+	m := &Manager{}
+	m.SetDNS01(DNS01{Hostnames: []string{"mail.mox.example", "*.mox.example"}})
+
+	test := func(host, expName string) {
+		t.Helper()
+		if name := m.dns01Name(host); name != expName {
+			t.Fatalf("dns01Name(%q), got %q, expected %q", host, name, expName)
+		}
+	}
+	test("mail.mox.example", "mail.mox.example")
+	test("MAIL.mox.example.", "mail.mox.example")
+	test("www.mox.example", "*.mox.example")
+	test("mox.example", "")
+	test("a.b.mox.example", "")
+	test("other.example", "")
+
+	if (&Manager{}).dns01Name("mail.mox.example") != "" {
+		t.Fatalf("dns01 name without dns01 config")
+	}
+}
+
+func TestRFC2136(t *testing.T) {
+	log := mlog.New("autotls", nil)
+	secret := []byte("0123456789abcdef0123456789abcdef")
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	tcheck(t, err, "listen")
+	defer ln.Close()
+
+	type update struct {
+		zone, name, value string
+		class             dnsmessage.Class
+		err               string
+	}
+	updates := make(chan update, 1)
+	var rcode atomic.Int32 // dnsmessage.RCode for responses.
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			var u update
+			msg, err := readMessage(conn)
+			if err == nil {
+				u, msg = checkUpdate(msg, secret)
+				var h dnsmessage.Header
+				var p dnsmessage.Parser
+				h, _ = p.Start(msg)
+				h.Response = true
+				h.RCode = dnsmessage.RCode(rcode.Load())
+				b := dnsmessage.NewBuilder(nil, h)
+				resp, _ := b.Finish()
+				conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(resp))), resp...))
+			} else {
+				u.err = err.Error()
+			}
+			conn.Close()
+			updates <- u
+		}
+	}()
+
+	u := RFC2136{Server: ln.Addr().String(), KeyName: "mox-key", Secret: secret}
+	ctx := context.Background()
+
+	err = u.AddTXT(ctx, log, "_acme-challenge.mail.mox.example", "value1")
+	tcheck(t, err, "add txt")
+	x := <-updates
+	if x != (update{"mox.example.", "_acme-challenge.mail.mox.example.", "value1", dnsmessage.ClassINET, ""}) {
+		t.Fatalf("unexpected update %#v", x)
+	}
+
+	u.Zone = "mail.mox.example"
+	err = u.RemoveTXT(ctx, log, "_acme-challenge.mail.mox.example", "value1")
+	tcheck(t, err, "remove txt")
+	x = <-updates
+	if x != (update{"mail.mox.example.", "_acme-challenge.mail.mox.example.", "value1", dnsmessage.Class(254), ""}) {
+		t.Fatalf("unexpected update %#v", x)
+	}
+
+	// Server refusing the update.
+	rcode.Store(int32(dnsmessage.RCodeRefused))
+	err = u.AddTXT(ctx, log, "_acme-challenge.mail.mox.example", "value1")
+	if err == nil {
+		t.Fatalf("add txt succeeded, expected error for refused update")
+	}
+	<-updates
+
+	u.Algorithm = "hmac-md5"
+	if err := u.AddTXT(ctx, log, "_acme-challenge.mail.mox.example", "value1"); err == nil {
+		t.Fatalf("unsupported algorithm accepted")
+	}
+}
+
+func readMessage(r io.Reader) ([]byte, error) {
+	var sizebuf [2]byte
+	if _, err := io.ReadFull(r, sizebuf[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(sizebuf[:]))
+	_, err := io.ReadFull(r, msg)
+	return msg, err
+}
+
+// checkUpdate verifies the TSIG at the end of msg, and returns the update and the
+// message without TSIG.
+func checkUpdate(msg []byte, secret []byte) (u struct {
+	zone, name, value string
+	class             dnsmessage.Class
+	err               string
+}, orig []byte) {
+	keyName := wireName("mox-key")
+	algName := wireName("hmac-sha256")
+	rdlen := len(algName) + 6 + 2 + 2 + sha256.Size + 2 + 2 + 2
+	n := len(msg) - (len(keyName) + 10 + rdlen)
+	if n < 12 {
+		u.err = "message too short"
+		return u, msg
+	}
+	tsig := msg[n:]
+	orig = append([]byte{}, msg[:n]...)
+	binary.BigEndian.PutUint16(orig[10:12], binary.BigEndian.Uint16(orig[10:12])-1)
+
+	if !bytes.HasPrefix(tsig, keyName) {
+		u.err = "bad key name"
+		return u, orig
+	}
+	rdata := tsig[len(keyName)+10:]
+	timeFudge := rdata[len(algName) : len(algName)+8]
+	mac := rdata[len(algName)+10 : len(algName)+10+sha256.Size]
+
+	h := hmac.New(sha256.New, secret)
+	h.Write(orig)
+	h.Write(keyName)
+	h.Write([]byte{0, 255, 0, 0, 0, 0})
+	h.Write(algName)
+	h.Write(timeFudge)
+	h.Write([]byte{0, 0, 0, 0})
+	if !hmac.Equal(mac, h.Sum(nil)) {
+		u.err = "bad mac"
+		return u, orig
+	}
+
+	var p dnsmessage.Parser
+	hdr, err := p.Start(orig)
+	if err != nil || hdr.OpCode != 5 {
+		u.err = "bad header"
+		return u, orig
+	}
+	q, err := p.Question()
+	if err != nil || q.Type != dnsmessage.TypeSOA {
+		u.err = "bad zone"
+		return u, orig
+	}
+	u.zone = q.Name.String()
+	p.SkipAllQuestions()
+	p.SkipAllAnswers()
+	rh, err := p.AuthorityHeader()
+	if err != nil || rh.Type != dnsmessage.TypeTXT {
+		u.err = "bad update"
+		return u, orig
+	}
+	txt, err := p.TXTResource()
+	if err != nil {
+		u.err = "bad txt"
+		return u, orig
+	}
+	u.name = rh.Name.String()
+	u.class = rh.Class
+	u.value = strings.Join(txt.TXT, "")
+	return u, orig
+}
+
+func TestCommandUpdater(t *testing.T) {
+	log := mlog.New("autotls", nil)
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+
+	c := Command{Command: `echo "$ACME_DNS01_ACTION $ACME_DNS01_NAME $ACME_DNS01_ZONE $ACME_DNS01_VALUE" >>` + out}
+	err := c.AddTXT(context.Background(), log, "_acme-challenge.mail.mox.example", "value1")
+	tcheck(t, err, "add txt")
+	err = c.RemoveTXT(context.Background(), log, "_acme-challenge.mail.mox.example", "value1")
+	tcheck(t, err, "remove txt")
+	buf, err := os.ReadFile(out)
+	tcheck(t, err, "read output")
+	exp := "add _acme-challenge.mail.mox.example. mox.example. value1\nremove _acme-challenge.mail.mox.example. mox.example. value1\n"
+	if string(buf) != exp {
+		t.Fatalf("command output, got %q, expected %q", buf, exp)
+	}
+
+	c = Command{Command: "echo failing; exit 1"}
+	if err := c.AddTXT(context.Background(), log, "_acme-challenge.mail.mox.example", "value1"); err == nil || !strings.Contains(err.Error(), "failing") {
+		t.Fatalf("failing command, got err %v, expected error with output", err)
+	}
+}
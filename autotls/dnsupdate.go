@@ -0,0 +1,255 @@
+package autotls
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/publicsuffix"
+)
+
+// RFC2136 adds and removes TXT records with DNS UPDATE messages, RFC 2136,
+// authenticated with TSIG, RFC 8945.
+type RFC2136 struct {
+	Server    string // host:port, messages are sent over TCP.
+	Zone      string // Zone to update, without trailing dot. If empty, the organizational domain of the name is used.
+	KeyName   string // Name of the TSIG key, without trailing dot.
+	Algorithm string // hmac-sha256, hmac-sha384 or hmac-sha512. Default hmac-sha256.
+	Secret    []byte
+}
+
+// TSIGAlgorithm returns the hash function for a TSIG algorithm name, or nil if
+// not supported. An empty name is hmac-sha256.
+func TSIGAlgorithm(name string) func() hash.Hash {
+	switch strings.ToLower(name) {
+	case "", "hmac-sha256":
+		return sha256.New
+	case "hmac-sha384":
+		return sha512.New384
+	case "hmac-sha512":
+		return sha512.New
+	}
+	return nil
+}
+
+// AddTXT adds a TXT record with value to name.
+func (u RFC2136) AddTXT(ctx context.Context, log mlog.Log, name, value string) error {
+	return u.update(ctx, log, name, value, true)
+}
+
+// RemoveTXT removes the TXT record with value from name.
+func (u RFC2136) RemoveTXT(ctx context.Context, log mlog.Log, name, value string) error {
+	return u.update(ctx, log, name, value, false)
+}
+
+func (u RFC2136) update(ctx context.Context, log mlog.Log, name, value string, add bool) error {
+	zone := u.Zone
+	if zone == "" {
+		d, err := dns.ParseDomain(strings.TrimPrefix(name, "_acme-challenge."))
+		if err != nil {
+			return fmt.Errorf("parsing domain to find zone: %v", err)
+		}
+		zone = publicsuffix.Lookup(ctx, log.Logger, d).ASCII
+	}
+	log.Debug("sending dns update", slog.String("name", name), slog.String("zone", zone), slog.Bool("add", add), slog.String("server", u.Server))
+
+	msg, id, err := u.message(name, zone, value, add, time.Now())
+	if err != nil {
+		return err
+	}
+
+	dialer := net.Dialer{Timeout: 30 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", u.Server)
+	if err != nil {
+		return fmt.Errorf("dial dns server: %v", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		err := conn.SetDeadline(deadline)
+		log.Check(err, "setting deadline on dns connection")
+	}
+
+	buf := binary.BigEndian.AppendUint16(nil, uint16(len(msg)))
+	buf = append(buf, msg...)
+	if _, err := conn.Write(buf); err != nil {
+		return fmt.Errorf("write update message: %v", err)
+	}
+
+	var sizebuf [2]byte
+	if _, err := io.ReadFull(conn, sizebuf[:]); err != nil {
+		return fmt.Errorf("read response size: %v", err)
+	}
+	resp := make([]byte, binary.BigEndian.Uint16(sizebuf[:]))
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return fmt.Errorf("read response: %v", err)
+	}
+	var p dnsmessage.Parser
+	h, err := p.Start(resp)
+	if err != nil {
+		return fmt.Errorf("parsing response: %v", err)
+	}
+	if h.ID != id {
+		return fmt.Errorf("response has id %d, expected %d", h.ID, id)
+	}
+	if h.RCode != dnsmessage.RCodeSuccess {
+		return fmt.Errorf("update failed with rcode %s", h.RCode)
+	}
+	return nil
+}
+
+// message returns a DNS UPDATE message for the TXT record, signed with TSIG.
+func (u RFC2136) message(name, zone, value string, add bool, now time.Time) ([]byte, uint16, error) {
+	hashFn := TSIGAlgorithm(u.Algorithm)
+	if hashFn == nil {
+		return nil, 0, fmt.Errorf("unsupported tsig algorithm %q", u.Algorithm)
+	}
+	algorithm := strings.ToLower(u.Algorithm)
+	if algorithm == "" {
+		algorithm = "hmac-sha256"
+	}
+
+	var idbuf [2]byte
+	if _, err := cryptorand.Read(idbuf[:]); err != nil {
+		return nil, 0, fmt.Errorf("generating message id: %v", err)
+	}
+	id := binary.BigEndian.Uint16(idbuf[:])
+
+	zoneName, err := dnsmessage.NewName(zone + ".")
+	if err != nil {
+		return nil, 0, fmt.Errorf("zone name: %v", err)
+	}
+	rrName, err := dnsmessage.NewName(name + ".")
+	if err != nil {
+		return nil, 0, fmt.Errorf("record name: %v", err)
+	}
+
+	// Update section records use class NONE with TTL 0 for removal of a single record,
+	// RFC 2136 section 2.5.4.
+	class := dnsmessage.ClassINET
+	var ttl uint32 = 60
+	if !add {
+		class = dnsmessage.Class(254)
+		ttl = 0
+	}
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, OpCode: 5})
+	if err := b.StartQuestions(); err != nil {
+		return nil, 0, err
+	}
+	// The zone section.
+	if err := b.Question(dnsmessage.Question{Name: zoneName, Type: dnsmessage.TypeSOA, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, 0, fmt.Errorf("adding zone: %v", err)
+	}
+	// The update section.
+	if err := b.StartAuthorities(); err != nil {
+		return nil, 0, err
+	}
+	hdr := dnsmessage.ResourceHeader{Name: rrName, Class: class, TTL: ttl}
+	if err := b.TXTResource(hdr, dnsmessage.TXTResource{TXT: []string{value}}); err != nil {
+		return nil, 0, fmt.Errorf("adding txt record: %v", err)
+	}
+	msg, err := b.Finish()
+	if err != nil {
+		return nil, 0, fmt.Errorf("building message: %v", err)
+	}
+
+	keyName := wireName(u.KeyName)
+	algName := wireName(algorithm)
+	t := uint64(now.Unix())
+	timeBuf := []byte{byte(t >> 40), byte(t >> 32), byte(t >> 24), byte(t >> 16), byte(t >> 8), byte(t)}
+	const fudge = 300
+
+	// MAC over the message and TSIG variables, RFC 8945 section 4.3.3.
+	mac := hmac.New(hashFn, u.Secret)
+	mac.Write(msg)
+	mac.Write(keyName)
+	mac.Write([]byte{0, 255, 0, 0, 0, 0}) // Class ANY, TTL 0.
+	mac.Write(algName)
+	mac.Write(timeBuf)
+	mac.Write([]byte{fudge >> 8, fudge & 0xff, 0, 0, 0, 0}) // Fudge, error, other len.
+	sum := mac.Sum(nil)
+
+	var rdata bytes.Buffer
+	rdata.Write(algName)
+	rdata.Write(timeBuf)
+	rdata.Write([]byte{fudge >> 8, fudge & 0xff})
+	rdata.Write(binary.BigEndian.AppendUint16(nil, uint16(len(sum))))
+	rdata.Write(sum)
+	rdata.Write(idbuf[:])
+	rdata.Write([]byte{0, 0, 0, 0}) // Error, other len.
+
+	msg = append(msg, keyName...)
+	msg = append(msg, 0, 250, 0, 255, 0, 0, 0, 0) // Type TSIG, class ANY, TTL 0.
+	msg = binary.BigEndian.AppendUint16(msg, uint16(rdata.Len()))
+	msg = append(msg, rdata.Bytes()...)
+	binary.BigEndian.PutUint16(msg[10:12], binary.BigEndian.Uint16(msg[10:12])+1)
+	return msg, id, nil
+}
+
+// wireName returns the uncompressed wire format of a name, in lower case.
+func wireName(name string) []byte {
+	var b []byte
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			b = append(b, byte(len(label)))
+			b = append(b, label...)
+		}
+	}
+	return append(b, 0)
+}
+
+// Command adds and removes TXT records by running a shell command. The command
+// gets environment variables ACME_DNS01_ACTION ("add" or "remove"),
+// ACME_DNS01_NAME (with trailing dot), ACME_DNS01_ZONE (organizational domain,
+// with trailing dot) and ACME_DNS01_VALUE.
+type Command struct {
+	Command string
+}
+
+// AddTXT runs the command with action "add".
+func (c Command) AddTXT(ctx context.Context, log mlog.Log, name, value string) error {
+	return c.run(ctx, log, "add", name, value)
+}
+
+// RemoveTXT runs the command with action "remove".
+func (c Command) RemoveTXT(ctx context.Context, log mlog.Log, name, value string) error {
+	return c.run(ctx, log, "remove", name, value)
+}
+
+func (c Command) run(ctx context.Context, log mlog.Log, action, name, value string) error {
+	var zone string
+	if d, err := dns.ParseDomain(strings.TrimPrefix(name, "_acme-challenge.")); err == nil {
+		zone = publicsuffix.Lookup(ctx, log.Logger, d).ASCII + "."
+	}
+	cmd := exec.CommandContext(ctx, "sh", "-c", c.Command)
+	cmd.Env = append(os.Environ(),
+		"ACME_DNS01_ACTION="+action,
+		"ACME_DNS01_NAME="+name+".",
+		"ACME_DNS01_ZONE="+zone,
+		"ACME_DNS01_VALUE="+value,
+	)
+	log.Debug("running dns-01 command", slog.String("action", action), slog.String("name", name))
+	buf, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("running command: %v, output: %q", err, strings.TrimSpace(string(buf)))
+	}
+	return nil
+}
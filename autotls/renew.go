@@ -0,0 +1,444 @@
+package autotls
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	mathrand "math/rand"
+	"net/http"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/autocert"
+
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/moxvar"
+)
+
+var metricRenewalInfo = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "mox_autotls_renewalinfo_renewal_total",
+		Help: "Certificates renewed early because the renewal information (ARI) of the ACME provider suggested it, by result.",
+	},
+	[]string{
+		"result", // ok, error
+	},
+)
+
+// For fetching the directory and renewal information.
+var httpClient = &http.Client{Timeout: time.Minute}
+
+// Start requests and renews certificates in the background until shutdown.
+// Certificates for DNS-01 names are loaded from the cache and requested when
+// absent or about to expire. Certificates are renewed early when the renewal
+// information (ARI) of the ACME provider suggests so, e.g. because they will be
+// revoked.
+func (m *Manager) Start(log mlog.Log) {
+	go func() {
+		defer func() {
+			x := recover()
+			if x != nil {
+				log.Error("recover from panic", slog.Any("panic", x))
+				debug.PrintStack()
+				metrics.PanicInc(metrics.Autotls)
+			}
+		}()
+
+		timer := time.NewTimer(0)
+		defer timer.Stop()
+		for {
+			select {
+			case <-m.shutdown:
+				return
+			case <-timer.C:
+			}
+
+			// Try again sooner after failures.
+			next := 6 * time.Hour
+			dns01OK := m.renewDNS01(log)
+			renewalInfoOK := m.renewRenewalInfo(log)
+			if !dns01OK || !renewalInfoOK {
+				next = time.Hour
+			}
+			timer.Reset(next)
+		}
+	}()
+}
+
+// requestContext returns a context for requesting a certificate, canceled at
+// shutdown.
+func (m *Manager) requestContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Minute)
+	go func() {
+		select {
+		case <-m.shutdown:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// request requests a new certificate for name with key. Authorize is called for
+// each authorization that isn't valid yet, and must fulfill a challenge. The
+// private key and certificate chain are returned in PEM, in the format of the
+// autocert cache.
+func (m *Manager) request(ctx context.Context, log mlog.Log, name string, key crypto.Signer, authorize func(ctx context.Context, authz *acme.Authorization) error) ([]byte, error) {
+	client := m.Manager.Client
+	var contact []string
+	if m.Manager.Email != "" {
+		contact = []string{"mailto:" + m.Manager.Email}
+	}
+	acct := &acme.Account{Contact: contact, ExternalAccountBinding: m.Manager.ExternalAccountBinding}
+	if _, err := client.Register(ctx, acct, m.Manager.Prompt); err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		var ae *acme.Error
+		if !errors.As(err, &ae) || ae.StatusCode != http.StatusConflict {
+			return nil, fmt.Errorf("registering acme account: %v", err)
+		}
+	}
+
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(name))
+	if err != nil {
+		return nil, fmt.Errorf("creating order: %v", err)
+	}
+	for _, authzURL := range order.AuthzURLs {
+		authz, err := client.GetAuthorization(ctx, authzURL)
+		if err != nil {
+			return nil, fmt.Errorf("get authorization: %v", err)
+		}
+		if authz.Status == acme.StatusValid {
+			continue
+		}
+		if err := authorize(ctx, authz); err != nil {
+			return nil, err
+		}
+	}
+	order, err = client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return nil, fmt.Errorf("waiting for order: %v", err)
+	}
+
+	csr, err := x509.CreateCertificateRequest(cryptorand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: name},
+		DNSNames: []string{name},
+	}, key)
+	if err != nil {
+		return nil, fmt.Errorf("creating certificate request: %v", err)
+	}
+	der, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, fmt.Errorf("finalizing order: %v", err)
+	}
+	return encodeKeyCert(key, der)
+}
+
+// encodeKeyCert returns the private key and certificates in PEM, as stored in the
+// autocert cache.
+func encodeKeyCert(key crypto.Signer, der [][]byte) ([]byte, error) {
+	var b bytes.Buffer
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		keyDER, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			return nil, fmt.Errorf("marshal private key: %v", err)
+		}
+		if err := pem.Encode(&b, &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}); err != nil {
+			return nil, fmt.Errorf("pem encode private key: %v", err)
+		}
+	case *rsa.PrivateKey:
+		if err := pem.Encode(&b, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}); err != nil {
+			return nil, fmt.Errorf("pem encode private key: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	for _, buf := range der {
+		if err := pem.Encode(&b, &pem.Block{Type: "CERTIFICATE", Bytes: buf}); err != nil {
+			return nil, fmt.Errorf("pem encode certificate: %v", err)
+		}
+	}
+	return b.Bytes(), nil
+}
+
+// findChallenge returns the challenge of type typ from the authorization.
+func findChallenge(authz *acme.Authorization, typ string) (*acme.Challenge, error) {
+	for _, ch := range authz.Challenges {
+		if ch.Type == typ {
+			return ch, nil
+		}
+	}
+	return nil, fmt.Errorf("acme provider did not offer %s challenge for %s", typ, authz.Identifier.Value)
+}
+
+// tlsalpn01Authorize fulfills the tls-alpn-01 challenge of the authorization. The
+// challenge certificate is stored in the cache, from where autocert serves it.
+func (m *Manager) tlsalpn01Authorize(ctx context.Context, log mlog.Log, authz *acme.Authorization) error {
+	client := m.Manager.Client
+
+	chal, err := findChallenge(authz, "tls-alpn-01")
+	if err != nil {
+		return err
+	}
+	domain := authz.Identifier.Value
+	cert, err := client.TLSALPN01ChallengeCert(chal.Token, domain)
+	if err != nil {
+		return fmt.Errorf("making tls-alpn-01 challenge certificate: %v", err)
+	}
+	key, ok := cert.PrivateKey.(crypto.Signer)
+	if !ok {
+		return fmt.Errorf("challenge certificate private key %T is not a signer", cert.PrivateKey)
+	}
+	data, err := encodeKeyCert(key, cert.Certificate)
+	if err != nil {
+		return err
+	}
+	tokenKey := domain + "+token"
+	if err := m.Manager.Cache.Put(ctx, tokenKey, data); err != nil {
+		return fmt.Errorf("storing challenge certificate: %v", err)
+	}
+	defer func() {
+		// Context may be canceled, we still want to clean up.
+		dctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		err := m.Manager.Cache.Delete(dctx, tokenKey)
+		log.Check(err, "removing challenge certificate from cache", slog.String("domain", domain))
+	}()
+
+	if _, err := client.Accept(ctx, chal); err != nil {
+		return fmt.Errorf("accepting challenge: %v", err)
+	}
+	if _, err := client.WaitAuthorization(ctx, authz.URI); err != nil {
+		return fmt.Errorf("waiting for authorization: %v", err)
+	}
+	return nil
+}
+
+// renewalWindow is the suggested window for renewing a certificate, from the
+// renewal information (ARI) of the ACME provider, RFC 9773.
+type renewalWindow struct {
+	CertID         string // Of the certificate the window is for.
+	Start          time.Time
+	End            time.Time
+	ExplanationURL string
+	Selected       time.Time // Random time in the window, at which we renew.
+}
+
+// certID returns the identifier of a certificate for renewal information: the
+// authority key identifier and the serial number, both base64url-encoded.
+func certID(leaf *x509.Certificate) (string, error) {
+	if len(leaf.AuthorityKeyId) == 0 {
+		return "", fmt.Errorf("certificate has no authority key identifier")
+	}
+	// DER encoding of the serial, with a leading zero byte if the high bit is set.
+	serial := leaf.SerialNumber.Bytes()
+	if len(serial) == 0 || serial[0]&0x80 != 0 {
+		serial = append([]byte{0}, serial...)
+	}
+	return base64.RawURLEncoding.EncodeToString(leaf.AuthorityKeyId) + "." + base64.RawURLEncoding.EncodeToString(serial), nil
+}
+
+// httpGet fetches url, returning an error for non-200 responses.
+func httpGet(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "mox/"+moxvar.Version)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("response status %s", resp.Status)
+	}
+	return resp, nil
+}
+
+// renewalInfoBaseURL returns the renewal information URL from the directory of
+// the ACME provider, or an empty string if the provider doesn't support it.
+func (m *Manager) renewalInfoBaseURL(ctx context.Context) (string, error) {
+	m.Lock()
+	u := m.renewalInfoURL
+	m.Unlock()
+	if u != nil {
+		return *u, nil
+	}
+
+	resp, err := httpGet(ctx, m.Manager.Client.DirectoryURL)
+	if err != nil {
+		return "", fmt.Errorf("fetching acme directory: %v", err)
+	}
+	defer resp.Body.Close()
+	var dir struct {
+		RenewalInfo string `json:"renewalInfo"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1024*1024)).Decode(&dir); err != nil {
+		return "", fmt.Errorf("parsing acme directory: %v", err)
+	}
+	m.Lock()
+	m.renewalInfoURL = &dir.RenewalInfo
+	m.Unlock()
+	return dir.RenewalInfo, nil
+}
+
+// renewalWindow fetches the suggested renewal window for the certificate for
+// host or DNS-01 name key. Nil is returned if the ACME provider doesn't support
+// renewal information. The selected renewal time is kept while the window
+// doesn't change.
+func (m *Manager) renewalWindow(ctx context.Context, key string, leaf *x509.Certificate) (*renewalWindow, error) {
+	baseURL, err := m.renewalInfoBaseURL(ctx)
+	if err != nil || baseURL == "" {
+		return nil, err
+	}
+	id, err := certID(leaf)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpGet(ctx, strings.TrimSuffix(baseURL, "/")+"/"+id)
+	if err != nil {
+		return nil, fmt.Errorf("fetching renewal info: %v", err)
+	}
+	defer resp.Body.Close()
+	var ri struct {
+		SuggestedWindow struct {
+			Start time.Time `json:"start"`
+			End   time.Time `json:"end"`
+		} `json:"suggestedWindow"`
+		ExplanationURL string `json:"explanationURL"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&ri); err != nil {
+		return nil, fmt.Errorf("parsing renewal info: %v", err)
+	}
+	start, end := ri.SuggestedWindow.Start, ri.SuggestedWindow.End
+	if start.IsZero() || end.Before(start) {
+		return nil, fmt.Errorf("invalid suggested renewal window %s - %s", start, end)
+	}
+
+	w := renewalWindow{CertID: id, Start: start, End: end, ExplanationURL: ri.ExplanationURL}
+	m.Lock()
+	defer m.Unlock()
+	if prev, ok := m.renewalWindows[key]; ok && prev.CertID == id && prev.Start.Equal(start) && prev.End.Equal(end) {
+		w.Selected = prev.Selected
+	} else {
+		w.Selected = start
+		if d := end.Sub(start); d > 0 {
+			w.Selected = start.Add(time.Duration(mathrand.Int63n(int64(d))))
+		}
+	}
+	m.renewalWindows[key] = w
+	return &w, nil
+}
+
+// renewalSuggested returns whether the renewal information of the ACME provider
+// suggests renewing the certificate for host or DNS-01 name key now. Errors are
+// logged, and result in false.
+func (m *Manager) renewalSuggested(ctx context.Context, log mlog.Log, key string, leaf *x509.Certificate) bool {
+	w, err := m.renewalWindow(ctx, key, leaf)
+	if err != nil {
+		log.Infox("getting renewal info for certificate", err, slog.String("name", key))
+		return false
+	} else if w == nil || time.Now().Before(w.Selected) {
+		return false
+	}
+	log.Info("acme provider suggests renewing certificate",
+		slog.String("name", key),
+		slog.Time("windowstart", w.Start),
+		slog.Time("windowend", w.End),
+		slog.String("explanationurl", w.ExplanationURL))
+	return true
+}
+
+// renewRenewalInfo renews certificates for allowed hosts early when the renewal
+// information of the ACME provider suggests so. It returns false if an error
+// occurred.
+func (m *Manager) renewRenewalInfo(log mlog.Log) bool {
+	hosts := m.Hostnames()
+	sort.Slice(hosts, func(i, j int) bool {
+		return hosts[i].Name() < hosts[j].Name()
+	})
+	ok := true
+	for _, host := range hosts {
+		m.Lock()
+		covered := m.dns01Name(host.ASCII) != ""
+		m.Unlock()
+		if covered {
+			continue
+		}
+		if err := m.renewalInfoEnsure(log, host); err != nil {
+			metricRenewalInfo.WithLabelValues("error").Inc()
+			log.Errorx("renewing certificate early", err, slog.Any("host", host))
+			ok = false
+		}
+	}
+	return ok
+}
+
+// renewalInfoEnsure requests a new certificate for host with a tls-alpn-01
+// challenge if the renewal information suggests so. Hosts without certificate are
+// skipped, autocert requests them on first use.
+func (m *Manager) renewalInfoEnsure(log mlog.Log, host dns.Domain) error {
+	ctx, cancel := m.requestContext()
+	defer cancel()
+
+	leaf, err := m.cachedCertOwn(ctx, host)
+	if err != nil {
+		return err
+	} else if leaf == nil || !time.Now().Before(leaf.NotAfter) || !m.renewalSuggested(ctx, log, host.ASCII, leaf) {
+		return nil
+	}
+
+	log.Info("requesting certificate early with tls-alpn-01 challenge", slog.Any("host", host))
+	var key crypto.Signer
+	if m.Manager.GetPrivateKey != nil {
+		key, err = m.Manager.GetPrivateKey(host.ASCII, autocert.KeyECDSAP256)
+	} else {
+		key, err = ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	}
+	if err != nil {
+		return fmt.Errorf("get private key: %v", err)
+	}
+	data, err := m.request(ctx, log, host.ASCII, key, func(ctx context.Context, authz *acme.Authorization) error {
+		return m.tlsalpn01Authorize(ctx, log, authz)
+	})
+	if err != nil {
+		return err
+	}
+	cert, err := parseKeyCert(data)
+	if err != nil {
+		return fmt.Errorf("parsing new certificate: %v", err)
+	}
+	// Autocert picks up the new certificate from the cache when it would renew.
+	if err := m.Manager.Cache.Put(ctx, host.ASCII, data); err != nil {
+		return fmt.Errorf("storing certificate in cache: %v", err)
+	}
+	metricRenewalInfo.WithLabelValues("ok").Inc()
+	log.Info("new certificate after early renewal", slog.Any("host", host), slog.Time("notafter", cert.Leaf.NotAfter))
+	m.Lock()
+	m.ariCerts[host.ASCII] = cert
+	delete(m.renewalWindows, host.ASCII)
+	m.Unlock()
+	return nil
+}
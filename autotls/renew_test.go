@@ -0,0 +1,245 @@
+package autotls
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/mlog"
+)
+
+// selfSigned returns a key and self-signed certificate for host in the format of
+// the cache.
+func selfSigned(t *testing.T, host string, serial int64) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	tcheck(t, err, "generate key")
+	tmpl := &x509.Certificate{
+		SerialNumber:   big.NewInt(serial),
+		Subject:        pkix.Name{CommonName: host},
+		DNSNames:       []string{host},
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(90 * 24 * time.Hour),
+		AuthorityKeyId: []byte{1, 2, 3, 4},
+	}
+	der, err := x509.CreateCertificate(cryptorand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	tcheck(t, err, "create certificate")
+	data, err := encodeKeyCert(key, [][]byte{der})
+	tcheck(t, err, "encode key and certificate")
+	return data
+}
+
+func testHello(host string) *tls.ClientHelloInfo {
+	return &tls.ClientHelloInfo{
+		ServerName:        host,
+		CipherSuites:      []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, tls.TLS_AES_128_GCM_SHA256},
+		SupportedCurves:   []tls.CurveID{tls.CurveP256},
+		SignatureSchemes:  []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+		SupportedVersions: []uint16{tls.VersionTLS13},
+	}
+}
+
+func TestFallbackRoutes(t *testing.T) {
+	log := mlog.New("autotls", nil)
+	ctx := context.Background()
+	dir := t.TempDir()
+	shutdown := make(chan struct{})
+	defer close(shutdown)
+
+	// ACME provider refusing all requests.
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+	}))
+	defer failing.Close()
+
+	primary, err := Load("primary", dir, "mox@localhost", failing.URL, "", nil, nil, shutdown)
+	tcheck(t, err, "load primary")
+	fallback, err := Load("fallback", dir, "mox@localhost", failing.URL, "", nil, nil, shutdown)
+	tcheck(t, err, "load fallback")
+	other, err := Load("other", dir, "mox@localhost", failing.URL, "", nil, nil, shutdown)
+	tcheck(t, err, "load other")
+
+	primary.SetFallbacks([]*Manager{fallback})
+	primary.SetRoutes(map[string]*Manager{"other.mox.example": other})
+	primary.SetAllowedHostnames(log, dns.MockResolver{}, map[dns.Domain]struct{}{{ASCII: "mox.example"}: {}}, nil, false)
+	other.SetAllowedHostnames(log, dns.MockResolver{}, map[dns.Domain]struct{}{{ASCII: "other.mox.example"}: {}}, nil, false)
+
+	// Hosts of the primary are allowed at the fallback.
+	err = fallback.HostPolicy(ctx, "mox.example")
+	tcheck(t, err, "hostpolicy at fallback")
+	if err := fallback.HostPolicy(ctx, "other.mox.example"); err == nil {
+		t.Fatalf("hostpolicy at fallback for host of other manager, expected error")
+	}
+
+	// Primary fails, certificate from fallback is used.
+	fallbackData := selfSigned(t, "mox.example", 1)
+	err = fallback.Manager.Cache.Put(ctx, "mox.example", fallbackData)
+	tcheck(t, err, "put certificate in fallback cache")
+	cert, err := primary.GetCertificate(testHello("mox.example"))
+	tcheck(t, err, "get certificate")
+	if cert == nil || cert.Leaf.SerialNumber.Int64() != 1 {
+		t.Fatalf("got certificate %v, expected certificate from fallback", cert)
+	}
+	primary.Lock()
+	until := primary.failed["mox.example"]
+	primary.Unlock()
+	if !time.Now().Before(until) {
+		t.Fatalf("primary not marked as failed for host")
+	}
+	// Fallback is used directly during fallback period.
+	cert, err = primary.GetCertificate(testHello("mox.example"))
+	tcheck(t, err, "get certificate again")
+	if cert == nil || cert.Leaf.SerialNumber.Int64() != 1 {
+		t.Fatalf("got certificate %v, expected certificate from fallback", cert)
+	}
+
+	exp, err := primary.CertExpiration(ctx, dns.Domain{ASCII: "mox.example"})
+	tcheck(t, err, "cert expiration")
+	if exp.IsZero() {
+		t.Fatalf("no expiration for certificate from fallback")
+	}
+
+	l, err := primary.Certificates(ctx)
+	tcheck(t, err, "certificates")
+	if len(l) != 1 || l[0].Host != "mox.example" || l[0].Provider != "fallback" || !l[0].Active || l[0].IssuerCommonName != "mox.example" {
+		t.Fatalf("certificates, got %#v, expected single active certificate from fallback", l)
+	}
+
+	// Certificate for routed host comes from the other manager.
+	err = other.Manager.Cache.Put(ctx, "other.mox.example", selfSigned(t, "other.mox.example", 2))
+	tcheck(t, err, "put certificate in other cache")
+	cert, err = primary.GetCertificate(testHello("other.mox.example"))
+	tcheck(t, err, "get certificate for routed host")
+	if cert == nil || cert.Leaf.SerialNumber.Int64() != 2 {
+		t.Fatalf("got certificate %v, expected certificate from other manager", cert)
+	}
+
+	// Host not allowed anywhere.
+	cert, err = primary.GetCertificate(testHello("unknown.mox.example"))
+	if err != nil || cert != nil {
+		t.Fatalf("got cert %v, err %v, expected nil certificate without error", cert, err)
+	}
+}
+
+func TestCertID(t *testing.T) {
+	// Example from RFC 9773.
+	leaf := &x509.Certificate{
+		AuthorityKeyId: []byte{0x69, 0x88, 0x5B, 0x6B, 0x87, 0x46, 0x40, 0x41, 0xE1, 0xB3, 0x7B, 0x84, 0x7B, 0xA0, 0xAE, 0x2C, 0xDE, 0x01, 0xC8, 0xD4},
+		SerialNumber:   big.NewInt(0x87654321),
+	}
+	id, err := certID(leaf)
+	tcheck(t, err, "certid")
+	if id != "aYhba4dGQEHhs3uEe6CuLN4ByNQ.AIdlQyE" {
+		t.Fatalf("certid, got %q", id)
+	}
+}
+
+func TestRenewalInfo(t *testing.T) {
+	log := mlog.New("autotls", nil)
+	ctx := context.Background()
+	shutdown := make(chan struct{})
+	defer close(shutdown)
+
+	var mu sync.Mutex
+	var start, end time.Time
+	var srvURL string
+	var requestedID string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.URL.Path == "/directory":
+			fmt.Fprintf(w, `{"renewalInfo": %q}`, srvURL+"/renewal-info/")
+		case strings.HasPrefix(r.URL.Path, "/renewal-info/"):
+			requestedID = strings.TrimPrefix(r.URL.Path, "/renewal-info/")
+			var ri struct {
+				SuggestedWindow struct {
+					Start time.Time `json:"start"`
+					End   time.Time `json:"end"`
+				} `json:"suggestedWindow"`
+				ExplanationURL string `json:"explanationURL"`
+			}
+			ri.SuggestedWindow.Start = start
+			ri.SuggestedWindow.End = end
+			ri.ExplanationURL = "https://acme.example/incident"
+			json.NewEncoder(w).Encode(ri)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	mu.Lock()
+	srvURL = srv.URL
+	mu.Unlock()
+	setWindow := func(s, e time.Time) {
+		mu.Lock()
+		defer mu.Unlock()
+		start, end = s, e
+	}
+
+	m, err := Load("test", t.TempDir(), "mox@localhost", srv.URL+"/directory", "", nil, nil, shutdown)
+	tcheck(t, err, "load manager")
+	m.SetAllowedHostnames(log, dns.MockResolver{}, map[dns.Domain]struct{}{{ASCII: "mox.example"}: {}}, nil, false)
+	cert, err := parseKeyCert(selfSigned(t, "mox.example", 3))
+	tcheck(t, err, "parse certificate")
+	err = m.Manager.Cache.Put(ctx, "mox.example", selfSigned(t, "mox.example", 3))
+	tcheck(t, err, "put certificate in cache")
+
+	// Window in the future, no renewal yet.
+	wstart := time.Now().Add(30 * 24 * time.Hour).Truncate(time.Second)
+	wend := wstart.Add(24 * time.Hour)
+	setWindow(wstart, wend)
+	if m.renewalSuggested(ctx, log, "mox.example", cert.Leaf) {
+		t.Fatalf("renewal suggested for window in the future")
+	}
+	mu.Lock()
+	gotID := requestedID
+	mu.Unlock()
+	if id, _ := certID(cert.Leaf); gotID != id {
+		t.Fatalf("renewal info requested for %q, expected %q", gotID, id)
+	}
+	w := m.renewalWindows["mox.example"]
+	if w.Selected.Before(wstart) || w.Selected.After(wend) {
+		t.Fatalf("selected renewal time %s not in window %s - %s", w.Selected, wstart, wend)
+	}
+	m.renewalSuggested(ctx, log, "mox.example", cert.Leaf)
+	if !m.renewalWindows["mox.example"].Selected.Equal(w.Selected) {
+		t.Fatalf("selected renewal time changed for same window")
+	}
+
+	// Certificate listing includes the renewal window, for the certificate in the cache.
+	m.Lock()
+	m.renewalWindows["mox.example"] = renewalWindow{CertID: "other", Start: wstart, End: wend}
+	m.Unlock()
+	l, err := m.Certificates(ctx)
+	tcheck(t, err, "certificates")
+	if len(l) != 1 || !l[0].RenewalStart.IsZero() {
+		t.Fatalf("certificates, got %#v, expected single certificate without renewal window", l)
+	}
+
+	// Window in the past, renew now, e.g. due to revocation.
+	setWindow(time.Now().Add(-2*time.Hour), time.Now().Add(-time.Hour))
+	if !m.renewalSuggested(ctx, log, "mox.example", cert.Leaf) {
+		t.Fatalf("renewal not suggested for window in the past")
+	}
+
+	// Provider without renewal info.
+	m.renewalInfoURL = new(string)
+	if m.renewalSuggested(ctx, log, "mox.example", cert.Leaf) {
+		t.Fatalf("renewal suggested without renewal info")
+	}
+}
@@ -1,10 +1,16 @@
 package main
 
 import (
+	"archive/tar"
+	"bufio"
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"io/fs"
 	"log/slog"
@@ -15,6 +21,7 @@ import (
 
 	"github.com/mjl-/bstore"
 
+	"github.com/mjl-/mox/admindb"
 	"github.com/mjl-/mox/dmarcdb"
 	"github.com/mjl-/mox/mox-"
 	"github.com/mjl-/mox/moxvar"
@@ -24,11 +31,95 @@ import (
 	"github.com/mjl-/mox/tlsrptdb"
 )
 
+// Name of file describing a backup, stored in the backup directory, or as last
+// file in a streamed backup.
+const backupManifestName = "backup-manifest.json"
+
+// Databases are hashed in chunks, so streamed incremental backups only have to
+// include the chunks that changed since the previous backup.
+const backupChunkSize = 64 * 1024
+
+// backupManifest lists the files in a backup.
+type backupManifest struct {
+	Version    int       // Currently 1.
+	Time       time.Time // Start of backup.
+	MoxVersion string
+	Previous   time.Time // Time of the backup this backup is incremental to. Zero for a full backup.
+	Files      []backupEntry
+}
+
+// backupEntry is a file in a backup.
+type backupEntry struct {
+	Path        string   // Relative to data directory, with slashes.
+	Size        int64    // Of full file.
+	SHA256      string   `json:",omitempty"` // Hex, of file contents. Not set for message files, those are checked against the index with "mox verifybackup -deep", or for databases.
+	Chunks      []string `json:",omitempty"` // For databases, truncated SHA-256 in hex of each chunk.
+	Omitted     bool     `json:",omitempty"` // Not in this streamed backup, unchanged since previous backup.
+	Delta       bool     `json:",omitempty"` // Database in streamed incremental backup, stored in file with suffix ".delta", with only the chunks listed in DeltaChunks.
+	DeltaChunks []int    `json:",omitempty"` // Chunks present in delta file, others are unchanged since the previous backup.
+}
+
+// readBackupManifest reads the manifest from a backup directory, or a manifest
+// file.
+func readBackupManifest(p string) (backupManifest, error) {
+	var m backupManifest
+	if fi, err := os.Stat(p); err == nil && fi.IsDir() {
+		p = filepath.Join(p, backupManifestName)
+	}
+	buf, err := os.ReadFile(p)
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(buf, &m); err != nil {
+		return m, fmt.Errorf("parsing manifest: %v", err)
+	}
+	if m.Version != 1 {
+		return m, fmt.Errorf("unsupported manifest version %d", m.Version)
+	}
+	return m, nil
+}
+
+// chunkHasher keeps a hash of each chunk of data written.
+type chunkHasher struct {
+	h      hash.Hash
+	n      int64
+	chunks []string
+}
+
+func (c *chunkHasher) Write(buf []byte) (int, error) {
+	if c.h == nil {
+		c.h = sha256.New()
+	}
+	n := len(buf)
+	for len(buf) > 0 {
+		k := min(len(buf), backupChunkSize-int(c.n%backupChunkSize))
+		c.h.Write(buf[:k])
+		c.n += int64(k)
+		buf = buf[k:]
+		if c.n%backupChunkSize == 0 {
+			c.chunks = append(c.chunks, hex.EncodeToString(c.h.Sum(nil)[:16]))
+			c.h.Reset()
+		}
+	}
+	return n, nil
+}
+
+// sums returns the hashes of all chunks, including the last partial chunk.
+func (c *chunkHasher) sums() []string {
+	l := c.chunks
+	if c.n%backupChunkSize != 0 {
+		l = append(l, hex.EncodeToString(c.h.Sum(nil)[:16]))
+	}
+	return l
+}
+
 func backupctl(ctx context.Context, ctl *ctl) {
 	/* protocol:
 	> "backup"
-	> destdir
+	> destdir, or "-" for a tar stream
 	> "verbose" or ""
+	> previous backup directory or manifest file for incremental backup, or ""
+	> path to write copy of manifest to, or ""
 	< stream
 	< "ok" or error
 	*/
@@ -40,12 +131,24 @@ func backupctl(ctx context.Context, ctl *ctl) {
 
 	dstDataDir := ctl.xread()
 	verbose := ctl.xread() == "verbose"
+	previous := ctl.xread()
+	manifestPath := ctl.xread()
+
+	// With a stream, the backup is written as tar file to the ctl stream, instead of
+	// log output.
+	stream := dstDataDir == "-"
 
 	// Set when an error is encountered. At the end, we warn if set.
 	var incomplete bool
 
 	// We'll be writing output, and logging both to mox and the ctl stream.
 	writer := ctl.writer()
+	var tw *tar.Writer
+	var bw *bufio.Writer
+	if stream {
+		bw = bufio.NewWriterSize(writer, 256*1024)
+		tw = tar.NewWriter(bw)
+	}
 
 	// Format easily readable output for the user.
 	formatLog := func(prefix, text string, err error, attrs ...slog.Attr) []byte {
@@ -62,12 +165,15 @@ func backupctl(ctx context.Context, ctl *ctl) {
 		return b.Bytes()
 	}
 
-	// Log an error to both the mox service as the user running "mox backup".
+	// Log an error to both the mox service as the user running "mox backup". When
+	// streaming, only to the mox service.
 	pkglogx := func(prefix, text string, err error, attrs ...slog.Attr) {
 		ctl.log.Errorx(text, err, attrs...)
 
-		_, werr := writer.Write(formatLog(prefix, text, err, attrs...))
-		ctl.xcheck(werr, "write to ctl")
+		if !stream {
+			_, werr := writer.Write(formatLog(prefix, text, err, attrs...))
+			ctl.xcheck(werr, "write to ctl")
+		}
 	}
 
 	// Log an error but don't mark backup as failed.
@@ -85,18 +191,40 @@ func backupctl(ctx context.Context, ctl *ctl) {
 	// If verbose is enabled, log to the cli command. Always log as info level.
 	xvlog := func(text string, attrs ...slog.Attr) {
 		ctl.log.Info(text, attrs...)
-		if verbose {
+		if verbose && !stream {
 			_, werr := writer.Write(formatLog("", text, nil, attrs...))
 			ctl.xcheck(werr, "write to ctl")
 		}
 	}
 
-	if _, err := os.Stat(dstDataDir); err == nil {
+	if stream {
+	} else if _, err := os.Stat(dstDataDir); err == nil {
 		xwarnx("destination data directory already exists", nil, slog.String("dir", dstDataDir))
 	}
 
 	srcDataDir := filepath.Clean(mox.DataDirPath("."))
 
+	manifest := backupManifest{Version: 1, Time: time.Now(), MoxVersion: moxvar.Version}
+
+	// For incremental backups, files of the previous backup.
+	var prevDir string
+	prevFiles := map[string]backupEntry{}
+	if previous != "" {
+		prev, err := readBackupManifest(previous)
+		if err != nil {
+			ctl.xcheck(err, "reading manifest of previous backup")
+		}
+		if fi, err := os.Stat(previous); err == nil && fi.IsDir() {
+			prevDir = previous
+		} else if !stream {
+			ctl.xerror("incremental backup to directory requires directory of previous backup")
+		}
+		manifest.Previous = prev.Time
+		for _, f := range prev.Files {
+			prevFiles[f.Path] = f
+		}
+	}
+
 	// When creating a file in the destination, we first ensure its directory exists.
 	// We track which directories we created, to prevent needless syscalls.
 	createdDirs := map[string]struct{}{}
@@ -111,40 +239,71 @@ func backupctl(ctx context.Context, ctl *ctl) {
 		}
 	}
 
-	// Backup a single file by copying (never hardlinking, the file may change).
-	backupFile := func(path string) {
-		tmFile := time.Now()
-		srcpath := filepath.Join(srcDataDir, path)
-		dstpath := filepath.Join(dstDataDir, path)
-
-		sf, err := os.Open(srcpath)
-		if err != nil {
-			xerrx("open source file (not backed up)", err, slog.String("srcpath", srcpath), slog.String("dstpath", dstpath))
-			return
+	// Write a file of size from r to the destination directory or stream.
+	writeFile := func(path string, size int64, r io.Reader) error {
+		if stream {
+			hdr := tar.Header{
+				Typeflag: tar.TypeReg,
+				Name:     filepath.ToSlash(path),
+				Size:     size,
+				Mode:     0660,
+				ModTime:  manifest.Time,
+			}
+			if err := tw.WriteHeader(&hdr); err != nil {
+				return fmt.Errorf("writing tar header: %v", err)
+			}
+			if _, err := io.CopyN(tw, r, size); err != nil {
+				return fmt.Errorf("writing to tar: %v", err)
+			}
+			return nil
 		}
-		defer sf.Close()
 
+		dstpath := filepath.Join(dstDataDir, path)
 		ensureDestDir(dstpath)
 		df, err := os.OpenFile(dstpath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0660)
 		if err != nil {
-			xerrx("creating destination file (not backed up)", err, slog.String("srcpath", srcpath), slog.String("dstpath", dstpath))
-			return
+			return fmt.Errorf("creating destination file: %v", err)
 		}
 		defer func() {
 			if df != nil {
 				df.Close()
 			}
 		}()
-		if _, err := io.Copy(df, sf); err != nil {
-			xerrx("copying file (not backed up properly)", err, slog.String("srcpath", srcpath), slog.String("dstpath", dstpath))
-			return
+		if _, err := io.Copy(df, r); err != nil {
+			return fmt.Errorf("copying file: %v", err)
 		}
 		err = df.Close()
 		df = nil
 		if err != nil {
-			xerrx("closing destination file (not backed up properly)", err, slog.String("srcpath", srcpath), slog.String("dstpath", dstpath))
+			return fmt.Errorf("closing destination file: %v", err)
+		}
+		return nil
+	}
+
+	// Backup a single file by copying (never hardlinking, the file may change).
+	backupFile := func(path string) {
+		tmFile := time.Now()
+		srcpath := filepath.Join(srcDataDir, path)
+
+		sf, err := os.Open(srcpath)
+		if err != nil {
+			xerrx("open source file (not backed up)", err, slog.String("srcpath", srcpath), slog.String("path", path))
+			return
+		}
+		defer sf.Close()
+		fi, err := sf.Stat()
+		if err != nil {
+			xerrx("stat source file (not backed up)", err, slog.String("srcpath", srcpath), slog.String("path", path))
 			return
 		}
+
+		h := sha256.New()
+		cr := &countWriter{}
+		if err := writeFile(path, fi.Size(), io.TeeReader(sf, io.MultiWriter(h, cr))); err != nil {
+			xerrx("copying file (not backed up properly)", err, slog.String("srcpath", srcpath), slog.String("path", path))
+			return
+		}
+		manifest.Files = append(manifest.Files, backupEntry{Path: filepath.ToSlash(path), Size: cr.n, SHA256: hex.EncodeToString(h.Sum(nil))})
 		xvlog("backed up file", slog.String("path", path), slog.Duration("duration", time.Since(tmFile)))
 	}
 
@@ -152,7 +311,6 @@ func backupctl(ctx context.Context, ctl *ctl) {
 	backupDir := func(dir string) {
 		tmDir := time.Now()
 		srcdir := filepath.Join(srcDataDir, dir)
-		dstdir := filepath.Join(dstDataDir, dir)
 		err := filepath.WalkDir(srcdir, func(srcpath string, d fs.DirEntry, err error) error {
 			if err != nil {
 				xerrx("walking file (not backed up)", err, slog.String("srcpath", srcpath))
@@ -167,17 +325,18 @@ func backupctl(ctx context.Context, ctl *ctl) {
 		if err != nil {
 			xerrx("copying directory (not backed up properly)", err,
 				slog.String("srcdir", srcdir),
-				slog.String("dstdir", dstdir),
+				slog.String("dir", dir),
 				slog.Duration("duration", time.Since(tmDir)))
 			return
 		}
 		xvlog("backed up directory", slog.String("dir", dir), slog.Duration("duration", time.Since(tmDir)))
 	}
 
-	// Backup a database by copying it in a readonly transaction.
+	// Backup a database by copying it in a readonly transaction. If fn is not nil, it
+	// is called in the same transaction, e.g. to list the message files to back up.
 	// Always logs on error, so caller doesn't have to, but also returns the error so
 	// callers can see result.
-	backupDB := func(db *bstore.DB, path string) (rerr error) {
+	backupDB := func(db *bstore.DB, path string, fn func(tx *bstore.Tx) error) (rerr error) {
 		defer func() {
 			if rerr != nil {
 				xerrx("backing up database", rerr, slog.String("path", path))
@@ -186,9 +345,18 @@ func backupctl(ctx context.Context, ctl *ctl) {
 
 		tmDB := time.Now()
 
-		dstpath := filepath.Join(dstDataDir, path)
-		ensureDestDir(dstpath)
-		df, err := os.OpenFile(dstpath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0660)
+		// The database is written to a file first: to the destination directory, or to a
+		// temporary file when streaming, because the size must be known before writing to
+		// the tar file, and for incremental backups only the changed chunks are written.
+		var df *os.File
+		var err error
+		if stream {
+			df, err = os.CreateTemp(mox.DataDirPath("tmp"), "backup-db")
+		} else {
+			dstpath := filepath.Join(dstDataDir, path)
+			ensureDestDir(dstpath)
+			df, err = os.OpenFile(dstpath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0660)
+		}
 		if err != nil {
 			return fmt.Errorf("creating destination file: %v", err)
 		}
@@ -196,7 +364,13 @@ func backupctl(ctx context.Context, ctl *ctl) {
 			if df != nil {
 				df.Close()
 			}
+			if stream {
+				err := os.Remove(df.Name())
+				ctl.log.Check(err, "removing temporary database file")
+			}
 		}()
+		ch := &chunkHasher{}
+		var size int64
 		err = db.Read(ctx, func(tx *bstore.Tx) error {
 			// Using regular WriteTo seems fine, and fast. It just copies pages.
 			//
@@ -208,17 +382,60 @@ func backupctl(ctx context.Context, ctl *ctl) {
 			// Tests with WriteTo and os.O_DIRECT were slower than without O_DIRECT, but
 			// probably because everything fit in the page cache. It may be better to use
 			// O_DIRECT when copying many large or inactive databases.
-			_, err := tx.WriteTo(df)
+			n, err := tx.WriteTo(io.MultiWriter(df, ch))
+			size = n
+			if err == nil && fn != nil {
+				err = fn(tx)
+			}
 			return err
 		})
 		if err != nil {
 			return fmt.Errorf("copying database: %v", err)
 		}
+		entry := backupEntry{Path: filepath.ToSlash(path), Size: size, Chunks: ch.sums()}
+
+		if stream {
+			// Only include changed chunks if the previous backup has this database.
+			var chunks []int
+			prev, ok := prevFiles[entry.Path]
+			for i, sum := range entry.Chunks {
+				if !ok || i >= len(prev.Chunks) || prev.Chunks[i] != sum {
+					chunks = append(chunks, i)
+				}
+			}
+			var r io.Reader
+			var n int64
+			name := path
+			if ok && len(prev.Chunks) > 0 {
+				entry.Delta = true
+				entry.DeltaChunks = chunks
+				name += ".delta"
+				var readers []io.Reader
+				for _, i := range chunks {
+					off := int64(i) * backupChunkSize
+					k := min(backupChunkSize, size-off)
+					readers = append(readers, io.NewSectionReader(df, off, k))
+					n += k
+				}
+				r = io.MultiReader(readers...)
+			} else {
+				r = io.NewSectionReader(df, 0, size)
+				n = size
+			}
+			if err := writeFile(name, n, r); err != nil {
+				return err
+			}
+		} else if err := df.Sync(); err != nil {
+			return fmt.Errorf("sync destination database: %v", err)
+		}
 		err = df.Close()
-		df = nil
 		if err != nil {
 			return fmt.Errorf("closing destination database after copy: %v", err)
 		}
+		if !stream {
+			df = nil
+		}
+		manifest.Files = append(manifest.Files, entry)
 		xvlog("backed up database file", slog.String("path", path), slog.Duration("duration", time.Since(tmDB)))
 		return nil
 	}
@@ -269,26 +486,85 @@ func backupctl(ctx context.Context, ctl *ctl) {
 		return false, nil
 	}
 
+	// Add a message file to the backup. Message files never change. For incremental
+	// backups, message files already in the previous backup are linked/copied from
+	// the previous backup directory, or omitted from a stream. Otherwise the file is
+	// hardlinked/copied from the data directory, or written to the stream.
+	var nlinked, ncopied, nomitted int
+	backupMessage := func(path string) error {
+		srcpath := filepath.Join(srcDataDir, path)
+		fi, err := os.Stat(srcpath)
+		if err != nil {
+			return err
+		}
+		entry := backupEntry{Path: filepath.ToSlash(path), Size: fi.Size()}
+		if prev, ok := prevFiles[entry.Path]; ok && prev.Size == fi.Size() {
+			if stream {
+				entry.Omitted = true
+				manifest.Files = append(manifest.Files, entry)
+				nomitted++
+				return nil
+			}
+			srcpath = filepath.Join(prevDir, path)
+		}
+		if stream {
+			f, err := os.Open(srcpath)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if err := writeFile(path, fi.Size(), f); err != nil {
+				return err
+			}
+			ncopied++
+		} else if linked, err := linkOrCopy(srcpath, filepath.Join(dstDataDir, path)); err != nil {
+			return err
+		} else if linked {
+			nlinked++
+		} else {
+			ncopied++
+		}
+		manifest.Files = append(manifest.Files, entry)
+		return nil
+	}
+	resetCounts := func() {
+		nlinked, ncopied, nomitted = 0, 0, 0
+	}
+
 	// Start making the backup.
 	tmStart := time.Now()
 
-	ctl.log.Print("making backup", slog.String("destdir", dstDataDir))
+	ctl.log.Print("making backup", slog.String("destdir", dstDataDir), slog.String("previous", previous))
 
-	err := os.MkdirAll(dstDataDir, 0770)
-	if err != nil {
-		xerrx("creating destination data directory", err)
+	if !stream {
+		err := os.MkdirAll(dstDataDir, 0770)
+		if err != nil {
+			xerrx("creating destination data directory", err)
+		}
 	}
 
-	if err := os.WriteFile(filepath.Join(dstDataDir, "moxversion"), []byte(moxvar.Version), 0660); err != nil {
+	if err := writeFile("moxversion", int64(len(moxvar.Version)), strings.NewReader(moxvar.Version)); err != nil {
 		xerrx("writing moxversion", err)
+	} else {
+		sum := sha256.Sum256([]byte(moxvar.Version))
+		manifest.Files = append(manifest.Files, backupEntry{Path: "moxversion", Size: int64(len(moxvar.Version)), SHA256: hex.EncodeToString(sum[:])})
 	}
-	backupDB(dmarcdb.ReportsDB, "dmarcrpt.db")
-	backupDB(dmarcdb.EvalDB, "dmarceval.db")
-	backupDB(mtastsdb.DB, "mtasts.db")
-	backupDB(tlsrptdb.ReportDB, "tlsrpt.db")
-	backupDB(tlsrptdb.ResultDB, "tlsrptresult.db")
+	backupDB(dmarcdb.ReportsDB, "dmarcrpt.db", nil)
+	backupDB(dmarcdb.EvalDB, "dmarceval.db", nil)
+	backupDB(mtastsdb.DB, "mtasts.db", nil)
+	backupDB(tlsrptdb.ReportDB, "tlsrpt.db", nil)
+	backupDB(tlsrptdb.ResultDB, "tlsrptresult.db", nil)
+	backupDB(admindb.DB, "admin.db", nil)
 	backupFile("receivedid.key")
 
+	// Shared junk filter is optional.
+	if err := store.SharedJunkFilterBackup(ctx, ctl.log, func(db *bstore.DB) {
+		backupDB(db, store.SharedJunkFilterFiles[0], nil)
+		backupFile(store.SharedJunkFilterFiles[1])
+	}); err != nil {
+		xerrx("backing up shared junk filter", err)
+	}
+
 	// Acme directory is optional.
 	srcAcmeDir := filepath.Join(srcDataDir, "acme")
 	if _, err := os.Stat(srcAcmeDir); err == nil {
@@ -301,53 +577,38 @@ func backupctl(ctx context.Context, ctl *ctl) {
 	backupQueue := func(path string) {
 		tmQueue := time.Now()
 
-		if err := backupDB(queue.DB, path); err != nil {
-			xerrx("queue not backed up", err, slog.String("path", path), slog.Duration("duration", time.Since(tmQueue)))
-			return
-		}
-
-		dstdbpath := filepath.Join(dstDataDir, path)
-		db, err := bstore.Open(ctx, dstdbpath, &bstore.Options{MustExist: true}, queue.DBTypes...)
+		// List the message files in the same transaction as the database copy.
+		var ids []int64
+		err := backupDB(queue.DB, path, func(tx *bstore.Tx) error {
+			return bstore.QueryTx[queue.Msg](tx).ForEach(func(m queue.Msg) error {
+				ids = append(ids, m.ID)
+				return nil
+			})
+		})
 		if err != nil {
-			xerrx("open copied queue database", err, slog.String("dstpath", dstdbpath), slog.Duration("duration", time.Since(tmQueue)))
+			xerrx("queue not backed up", err, slog.String("path", path), slog.Duration("duration", time.Since(tmQueue)))
 			return
 		}
 
-		defer func() {
-			if db != nil {
-				err := db.Close()
-				ctl.log.Check(err, "closing new queue db")
-			}
-		}()
-
 		// Link/copy known message files. Warn if files are missing or unexpected
 		// (though a message file could have been removed just now due to delivery, or a
 		// new message may have been queued).
 		tmMsgs := time.Now()
 		seen := map[string]struct{}{}
-		var nlinked, ncopied int
-		err = bstore.QueryDB[queue.Msg](ctx, db).ForEach(func(m queue.Msg) error {
-			mp := store.MessagePath(m.ID)
+		resetCounts()
+		for _, id := range ids {
+			mp := store.MessagePath(id)
 			seen[mp] = struct{}{}
-			srcpath := filepath.Join(srcDataDir, "queue", mp)
-			dstpath := filepath.Join(dstDataDir, "queue", mp)
-			if linked, err := linkOrCopy(srcpath, dstpath); err != nil {
-				xerrx("linking/copying queue message", err, slog.String("srcpath", srcpath), slog.String("dstpath", dstpath))
-			} else if linked {
-				nlinked++
-			} else {
-				ncopied++
+			qp := filepath.Join("queue", mp)
+			if err := backupMessage(qp); err != nil {
+				xerrx("linking/copying queue message", err, slog.String("path", qp))
 			}
-			return nil
-		})
-		if err != nil {
-			xerrx("processing queue messages (not backed up properly)", err, slog.Duration("duration", time.Since(tmMsgs)))
-		} else {
-			xvlog("queue message files linked/copied",
-				slog.Int("linked", nlinked),
-				slog.Int("copied", ncopied),
-				slog.Duration("duration", time.Since(tmMsgs)))
 		}
+		xvlog("queue message files linked/copied",
+			slog.Int("linked", nlinked),
+			slog.Int("copied", ncopied),
+			slog.Int("omitted", nomitted),
+			slog.Duration("duration", time.Since(tmMsgs)))
 
 		// Read through all files in queue directory and warn about anything we haven't handled yet.
 		tmWalk := time.Now()
@@ -387,9 +648,15 @@ func backupctl(ctx context.Context, ctl *ctl) {
 
 		tmAccount := time.Now()
 
-		// Copy database file.
+		// Copy database file, listing the message files in the same transaction.
 		dbpath := filepath.Join("accounts", acc.Name, "index.db")
-		err := backupDB(acc.DB, dbpath)
+		var ids []int64
+		err := backupDB(acc.DB, dbpath, func(tx *bstore.Tx) error {
+			return bstore.QueryTx[store.Message](tx).FilterEqual("Expunged", false).ForEach(func(m store.Message) error {
+				ids = append(ids, m.ID)
+				return nil
+			})
+		})
 		if err != nil {
 			xerrx("copying account database", err, slog.String("path", dbpath), slog.Duration("duration", time.Since(tmAccount)))
 		}
@@ -404,7 +671,7 @@ func backupctl(ctx context.Context, ctl *ctl) {
 		} else {
 			db := jf.DB()
 			jfpath := filepath.Join("accounts", acc.Name, "junkfilter.db")
-			backupDB(db, jfpath)
+			backupDB(db, jfpath, nil)
 			bloompath := filepath.Join("accounts", acc.Name, "junkfilter.bloom")
 			backupFile(bloompath)
 			db = nil
@@ -412,49 +679,29 @@ func backupctl(ctx context.Context, ctl *ctl) {
 			ctl.log.Check(err, "closing junkfilter")
 		}
 
-		dstdbpath := filepath.Join(dstDataDir, dbpath)
-		db, err := bstore.Open(ctx, dstdbpath, &bstore.Options{MustExist: true}, store.DBTypes...)
 		if err != nil {
-			xerrx("open copied account database", err, slog.String("dstpath", dstdbpath), slog.Duration("duration", time.Since(tmAccount)))
 			return
 		}
 
-		defer func() {
-			if db != nil {
-				err := db.Close()
-				ctl.log.Check(err, "close account database")
-			}
-		}()
-
 		// Link/copy known message files. Warn if files are missing or unexpected (though a
 		// message file could have been added just now due to delivery, or a message have
 		// been removed).
 		tmMsgs := time.Now()
 		seen := map[string]struct{}{}
-		var nlinked, ncopied int
-		err = bstore.QueryDB[store.Message](ctx, db).FilterEqual("Expunged", false).ForEach(func(m store.Message) error {
-			mp := store.MessagePath(m.ID)
+		resetCounts()
+		for _, id := range ids {
+			mp := store.MessagePath(id)
 			seen[mp] = struct{}{}
 			amp := filepath.Join("accounts", acc.Name, "msg", mp)
-			srcpath := filepath.Join(srcDataDir, amp)
-			dstpath := filepath.Join(dstDataDir, amp)
-			if linked, err := linkOrCopy(srcpath, dstpath); err != nil {
-				xerrx("linking/copying account message", err, slog.String("srcpath", srcpath), slog.String("dstpath", dstpath))
-			} else if linked {
-				nlinked++
-			} else {
-				ncopied++
+			if err := backupMessage(amp); err != nil {
+				xerrx("linking/copying account message", err, slog.String("path", amp))
 			}
-			return nil
-		})
-		if err != nil {
-			xerrx("processing account messages (not backed up properly)", err, slog.Duration("duration", time.Since(tmMsgs)))
-		} else {
-			xvlog("account message files linked/copied",
-				slog.Int("linked", nlinked),
-				slog.Int("copied", ncopied),
-				slog.Duration("duration", time.Since(tmMsgs)))
 		}
+		xvlog("account message files linked/copied",
+			slog.Int("linked", nlinked),
+			slog.Int("copied", ncopied),
+			slog.Int("omitted", nomitted),
+			slog.Duration("duration", time.Since(tmMsgs)))
 
 		// Read through all files in account directory and warn about anything we haven't handled yet.
 		tmWalk := time.Now()
@@ -480,6 +727,11 @@ func backupctl(ctx context.Context, ctl *ctl) {
 				return nil
 			}
 			ap := filepath.Join("accounts", acc.Name, p)
+			if l[0] == "upload" {
+				// Files uploaded in webmail for messages being composed.
+				backupFile(ap)
+				return nil
+			}
 			if strings.HasPrefix(p, "msg"+string(filepath.Separator)) {
 				xwarnx("backing up unrecognized file in account message directory (should be moved away)", nil, slog.String("path", ap))
 			} else {
@@ -513,7 +765,7 @@ func backupctl(ctx context.Context, ctl *ctl) {
 
 	// Copy all other files, that aren't part of the known files, databases, queue or accounts.
 	tmWalk := time.Now()
-	err = filepath.WalkDir(srcDataDir, func(srcpath string, d fs.DirEntry, err error) error {
+	err := filepath.WalkDir(srcDataDir, func(srcpath string, d fs.DirEntry, err error) error {
 		if err != nil {
 			xerrx("walking path", err, slog.String("path", srcpath))
 			return nil
@@ -523,7 +775,9 @@ func backupctl(ctx context.Context, ctl *ctl) {
 			return nil
 		}
 		p := srcpath[len(srcDataDir)+1:]
-		if p == "queue" || p == "acme" || p == "tmp" {
+		// Deduplicated message files are backed up through the account message files
+		// hardlinked to the blobs.
+		if p == "queue" || p == "acme" || p == "tmp" || p == "blobs" {
 			return fs.SkipDir
 		}
 		l := strings.Split(p, string(filepath.Separator))
@@ -540,11 +794,18 @@ func backupctl(ctx context.Context, ctl *ctl) {
 		}
 
 		switch p {
-		case "dmarcrpt.db", "dmarceval.db", "mtasts.db", "tlsrpt.db", "tlsrptresult.db", "receivedid.key", "ctl":
+		case "dmarcrpt.db", "dmarceval.db", "mtasts.db", "tlsrpt.db", "tlsrptresult.db", "admin.db", "receivedid.key", "ctl":
 			// Already handled.
 			return nil
-		case "lastknownversion": // Optional file, not yet handled.
+		case store.SharedJunkFilterFiles[0], store.SharedJunkFilterFiles[1]:
+			// Already handled, if present.
+			return nil
+		case "lastknownversion", "webpush-vapid.key", store.ScrubStateFile: // Optional files, not yet handled.
 		default:
+			// Message files of quarantined and held messages, referenced from admin.db.
+			if len(l) == 2 && (l[0] == "quarantine" || l[0] == "moderation") {
+				break
+			}
 			xwarnx("backing up unrecognized file", nil, slog.String("path", p))
 		}
 		backupFile(p)
@@ -556,8 +817,28 @@ func backupctl(ctx context.Context, ctl *ctl) {
 		xvlog("walking other files finished", slog.Duration("duration", time.Since(tmWalk)))
 	}
 
+	// Write the manifest last, it is used to verify backups, and as reference for
+	// incremental backups.
+	buf, err := json.MarshalIndent(manifest, "", "\t")
+	ctl.xcheck(err, "marshal manifest")
+	if err := writeFile(backupManifestName, int64(len(buf)), bytes.NewReader(buf)); err != nil {
+		xerrx("writing manifest", err)
+	}
+	if manifestPath != "" {
+		if err := os.WriteFile(manifestPath, buf, 0660); err != nil {
+			xerrx("writing copy of manifest", err, slog.String("path", manifestPath))
+		}
+	}
+
 	xvlog("backup finished", slog.Duration("duration", time.Since(tmStart)))
 
+	if stream {
+		err := tw.Close()
+		if err == nil {
+			err = bw.Flush()
+		}
+		ctl.xcheck(err, "finishing tar stream")
+	}
 	writer.xclose()
 
 	if incomplete {
@@ -566,3 +847,13 @@ func backupctl(ctx context.Context, ctl *ctl) {
 		ctl.xwriteok()
 	}
 }
+
+// countWriter counts the bytes written.
+type countWriter struct {
+	n int64
+}
+
+func (c *countWriter) Write(buf []byte) (int, error) {
+	c.n += int64(len(buf))
+	return len(buf), nil
+}
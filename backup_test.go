@@ -0,0 +1,297 @@
+//go:build !integration
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBackupChunkHasher(t *testing.T) {
+	chunkSum := func(buf []byte) string {
+		sum := sha256.Sum256(buf)
+		return hex.EncodeToString(sum[:16])
+	}
+
+	// Data of 2.5 chunks, written in pieces not aligned to chunks.
+	data := make([]byte, 2*backupChunkSize+backupChunkSize/2)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	ch := &chunkHasher{}
+	for buf := data; len(buf) > 0; {
+		n := min(len(buf), 1000)
+		ch.Write(buf[:n])
+		buf = buf[n:]
+	}
+	exp := []string{
+		chunkSum(data[:backupChunkSize]),
+		chunkSum(data[backupChunkSize : 2*backupChunkSize]),
+		chunkSum(data[2*backupChunkSize:]),
+	}
+	if l := ch.sums(); !reflect.DeepEqual(l, exp) {
+		t.Fatalf("got chunk sums %v, expected %v", l, exp)
+	}
+
+	// Exact multiple of chunk size has no trailing partial chunk.
+	ch = &chunkHasher{}
+	ch.Write(data[:2*backupChunkSize])
+	if l := ch.sums(); !reflect.DeepEqual(l, exp[:2]) {
+		t.Fatalf("got chunk sums %v, expected %v", l, exp[:2])
+	}
+
+	// No data, no chunks.
+	if l := (&chunkHasher{}).sums(); l != nil {
+		t.Fatalf("got chunk sums %v for no data, expected none", l)
+	}
+}
+
+func TestCheckBackupEntry(t *testing.T) {
+	sums, err := backupReadSums(bytes.NewReader(make([]byte, backupChunkSize+10)))
+	tcheck(t, err, "reading sums")
+	if sums.size != backupChunkSize+10 || len(sums.chunks) != 2 {
+		t.Fatalf("got size %d and %d chunks, expected %d and 2", sums.size, len(sums.chunks), backupChunkSize+10)
+	}
+	db := backupEntry{Path: "test.db", Size: sums.size, Chunks: sums.chunks}
+	file := backupEntry{Path: "moxversion", Size: sums.size, SHA256: sums.sha256}
+	msg := backupEntry{Path: "queue/msg/a/1", Size: sums.size}
+
+	test := func(e backupEntry, sums backupFileSums, expErr string) {
+		t.Helper()
+		err := checkBackupEntry(e, sums)
+		if expErr == "" && err != nil || expErr != "" && (err == nil || !strings.Contains(err.Error(), expErr)) {
+			t.Fatalf("got err %v, expected %q", err, expErr)
+		}
+	}
+
+	test(db, sums, "")
+	test(file, sums, "")
+	test(msg, sums, "")
+
+	bad := sums
+	bad.size++
+	test(msg, bad, "size is")
+	bad = sums
+	bad.sha256 = strings.Repeat("0", 64)
+	test(file, bad, "sha256 mismatch")
+	bad = sums
+	bad.chunks = []string{sums.chunks[0], strings.Repeat("0", 32)}
+	test(db, bad, "hash mismatch for chunk 1")
+	bad.chunks = sums.chunks[:1]
+	test(db, bad, "file has 1 chunks, manifest has 2")
+
+	// Delta files only have the listed chunks, and no check on size.
+	delta := db
+	delta.Delta = true
+	delta.DeltaChunks = []int{1}
+	test(delta, backupFileSums{size: 10, chunks: sums.chunks[1:]}, "")
+	test(delta, backupFileSums{size: 10, chunks: sums.chunks[:1]}, "hash mismatch for chunk 1")
+	test(delta, backupFileSums{}, "delta file has 0 chunks, manifest lists 1")
+	delta.DeltaChunks = []int{2}
+	test(delta, backupFileSums{size: 10, chunks: sums.chunks[1:]}, "delta chunk 2 out of range")
+	delta.DeltaChunks = nil
+	test(delta, backupFileSums{}, "")
+}
+
+// backupChecker gathers errors from verifying a backup.
+type backupChecker struct {
+	errs []string
+}
+
+func (c *backupChecker) checkf(err error, path, format string, args ...any) {
+	if err != nil {
+		c.errs = append(c.errs, fmt.Sprintf("%s: %s: %v", path, fmt.Sprintf(format, args...), err))
+	}
+}
+
+func TestVerifyBackupDir(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"moxversion":            []byte("v0.0.0"),
+		"queue/index.db":        bytes.Repeat([]byte("db"), backupChunkSize),
+		"accounts/mjl/msg/a/1":  []byte("message"),
+		"accounts/mjl/index.db": []byte("small db"),
+	}
+	var manifest backupManifest
+	manifest.Version = 1
+	for _, p := range []string{"moxversion", "queue/index.db", "accounts/mjl/msg/a/1", "accounts/mjl/index.db"} {
+		buf := files[p]
+		sums, err := backupReadSums(bytes.NewReader(buf))
+		tcheck(t, err, "reading sums")
+		e := backupEntry{Path: p, Size: sums.size}
+		if strings.HasSuffix(p, ".db") {
+			e.Chunks = sums.chunks
+		} else if !strings.Contains(p, "/msg/") {
+			e.SHA256 = sums.sha256
+		}
+		manifest.Files = append(manifest.Files, e)
+	}
+	writeFile := func(p string, buf []byte) {
+		t.Helper()
+		p = filepath.Join(dir, filepath.FromSlash(p))
+		err := os.MkdirAll(filepath.Dir(p), 0770)
+		tcheck(t, err, "mkdir")
+		err = os.WriteFile(p, buf, 0660)
+		tcheck(t, err, "write file")
+	}
+	writeManifest := func(m backupManifest) {
+		t.Helper()
+		buf, err := json.Marshal(m)
+		tcheck(t, err, "marshal manifest")
+		writeFile(backupManifestName, buf)
+	}
+	for p, buf := range files {
+		writeFile(p, buf)
+	}
+	writeManifest(manifest)
+
+	test := func(expErrs ...string) {
+		t.Helper()
+		var c backupChecker
+		verifyBackupDir(dir, c.checkf)
+		if len(c.errs) != len(expErrs) {
+			t.Fatalf("got errors %v, expected %v", c.errs, expErrs)
+		}
+		for i, s := range c.errs {
+			if !strings.Contains(s, expErrs[i]) {
+				t.Fatalf("got error %q, expected %q", s, expErrs[i])
+			}
+		}
+	}
+	test()
+
+	// Manifest can be read from the directory or the file.
+	m, err := readBackupManifest(dir)
+	tcheck(t, err, "read manifest")
+	if !reflect.DeepEqual(m, manifest) {
+		t.Fatalf("got manifest %#v, expected %#v", m, manifest)
+	}
+	_, err = readBackupManifest(filepath.Join(dir, backupManifestName))
+	tcheck(t, err, "read manifest file")
+
+	// Files not in the manifest are only a warning.
+	writeFile("accounts/mjl/extra", []byte("extra"))
+	test()
+
+	// Changed database chunk, changed file, and truncated message file.
+	db := bytes.Clone(files["queue/index.db"])
+	db[len(db)-1] = 'x'
+	writeFile("queue/index.db", db)
+	writeFile("moxversion", []byte("v0.0.1"))
+	writeFile("accounts/mjl/msg/a/1", []byte("messag"))
+	test(
+		"moxversion: checking file: sha256 mismatch",
+		"queue/index.db: checking file: hash mismatch for chunk 1",
+		"accounts/mjl/msg/a/1: checking file: size is 6, manifest has 7",
+	)
+	for p, buf := range files {
+		writeFile(p, buf)
+	}
+
+	// Missing file.
+	err = os.Remove(filepath.Join(dir, "accounts", "mjl", "index.db"))
+	tcheck(t, err, "remove file")
+	test("accounts/mjl/index.db: open file")
+	writeFile("accounts/mjl/index.db", files["accounts/mjl/index.db"])
+
+	// A manifest of a streamed incremental backup is not a complete backup directory.
+	xmanifest := manifest
+	xmanifest.Files = append([]backupEntry{}, manifest.Files...)
+	xmanifest.Files[2].Omitted = true
+	writeManifest(xmanifest)
+	test("file is part of a streamed incremental backup")
+
+	// Unsupported manifest.
+	xmanifest.Version = 2
+	writeManifest(xmanifest)
+	if _, err := readBackupManifest(dir); err == nil || !strings.Contains(err.Error(), "unsupported manifest version 2") {
+		t.Fatalf("got err %v, expected unsupported manifest version", err)
+	}
+	writeFile(backupManifestName, []byte("bogus"))
+	if _, err := readBackupManifest(dir); err == nil || !strings.Contains(err.Error(), "parsing manifest") {
+		t.Fatalf("got err %v, expected error parsing manifest", err)
+	}
+}
+
+func TestVerifyBackupTar(t *testing.T) {
+	dbBuf := make([]byte, 2*backupChunkSize)
+	for i := range dbBuf {
+		dbBuf[i] = byte(i % 251)
+	}
+	dbSums, err := backupReadSums(bytes.NewReader(dbBuf))
+	tcheck(t, err, "reading sums")
+	msgBuf := []byte("message")
+
+	type file struct {
+		name string
+		buf  []byte
+	}
+	// Make a tar file with the files, and the manifest at the end unless
+	// manifestIndex is set to a position.
+	makeTar := func(m backupManifest, manifestIndex int, files ...file) []byte {
+		t.Helper()
+		mbuf, err := json.Marshal(m)
+		tcheck(t, err, "marshal manifest")
+		if manifestIndex < 0 {
+			manifestIndex = len(files)
+		}
+		files = append(files[:manifestIndex:manifestIndex], append([]file{{backupManifestName, mbuf}}, files[manifestIndex:]...)...)
+		var b bytes.Buffer
+		tw := tar.NewWriter(&b)
+		for _, f := range files {
+			err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: f.name, Size: int64(len(f.buf)), Mode: 0660})
+			tcheck(t, err, "write tar header")
+			_, err = tw.Write(f.buf)
+			tcheck(t, err, "write tar file")
+		}
+		err = tw.Close()
+		tcheck(t, err, "close tar")
+		return b.Bytes()
+	}
+
+	test := func(tarBuf []byte, expErrs ...string) {
+		t.Helper()
+		var c backupChecker
+		verifyBackupTar(bytes.NewReader(tarBuf), c.checkf)
+		if len(c.errs) != len(expErrs) {
+			t.Fatalf("got errors %v, expected %v", c.errs, expErrs)
+		}
+		for i, s := range c.errs {
+			if !strings.Contains(s, expErrs[i]) {
+				t.Fatalf("got error %q, expected %q", s, expErrs[i])
+			}
+		}
+	}
+
+	full := backupManifest{
+		Version: 1,
+		Files: []backupEntry{
+			{Path: "queue/index.db", Size: dbSums.size, Chunks: dbSums.chunks},
+			{Path: "queue/msg/a/1", Size: int64(len(msgBuf))},
+		},
+	}
+	test(makeTar(full, -1, file{"queue/index.db", dbBuf}, file{"queue/msg/a/1", msgBuf}))
+	test(makeTar(full, -1, file{"queue/index.db", dbBuf}), "queue/msg/a/1: checking file: missing in backup")
+	test(makeTar(full, 1, file{"queue/index.db", dbBuf}, file{"queue/msg/a/1", msgBuf}), "queue/msg/a/1: reading tar file: file after manifest")
+	test(makeTar(full, -1, file{"queue/index.db", dbBuf[:backupChunkSize]}, file{"queue/msg/a/1", msgBuf}), "queue/index.db: checking file: size is")
+
+	// Incremental, with an omitted message file and only the second chunk of the
+	// database.
+	incr := full
+	incr.Files = []backupEntry{
+		{Path: "queue/index.db", Size: dbSums.size, Chunks: dbSums.chunks, Delta: true, DeltaChunks: []int{1}},
+		{Path: "queue/msg/a/1", Size: int64(len(msgBuf)), Omitted: true},
+	}
+	test(makeTar(incr, -1, file{"queue/index.db.delta", dbBuf[backupChunkSize:]}))
+	test(makeTar(incr, -1, file{"queue/index.db.delta", dbBuf[:backupChunkSize]}), "queue/index.db.delta: checking file: hash mismatch for chunk 1")
+	test(makeTar(incr, -1, file{"queue/index.db", dbBuf}), "queue/index.db.delta: checking file: missing in backup")
+}
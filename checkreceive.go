@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	cryptorand "crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/admindb"
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/moxvar"
+	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/store"
+)
+
+func cmdCheckreceive(c *cmd) {
+	c.params = "[-timeout duration] from-address echo-address"
+	c.help = `Send a test message to an echo service and check the reply.
+
+A message is sent from an address of an account of this mox to an external
+address that replies to incoming messages, such as an echo service for
+checking mail server configurations, or an account with an autoresponder on
+another mail server. Echo services typically include the results of their SPF,
+DKIM and DMARC verification of the test message in the reply.
+
+The running mox queues the message, and waits until the reply is delivered to
+the account of the from address. The reply is recognized by a unique token in
+the subject of the test message. The outcome of the delivery attempts is
+printed while waiting. At the end, a scorecard is printed with the results of
+the delivery, the reply, and the SPF, DKIM and DMARC results as reported by
+the remote in the reply, and as verified by mox for the incoming reply.
+
+Run this before pointing real users at a new installation. Exits with status 1
+if not all checks passed.
+`
+	timeout := 10 * time.Minute
+	c.flag.DurationVar(&timeout, "timeout", timeout, "how long to wait for delivery and the reply")
+	args := c.Parse()
+	if len(args) != 2 {
+		c.Usage()
+	}
+	mustLoadConfig()
+	ctlcmdCheckreceive(xctl(), args[0], args[1], timeout)
+}
+
+func ctlcmdCheckreceive(ctl *ctl, from, to string, timeout time.Duration) {
+	ctl.xwrite("checkreceive")
+	ctl.xwrite(from)
+	ctl.xwrite(to)
+	ctl.xwrite(timeout.String())
+	ctl.xreadok()
+	ctl.xstreamto(os.Stdout)
+	if ctl.xread() != "pass" {
+		os.Exit(1)
+	}
+}
+
+// checkreceiveVerdictRegexp matches results of authentication checks in a reply
+// from an echo service, both in the form of Authentication-Results headers, like
+// "spf=pass", and in the form of reports, like "DKIM check: pass".
+var checkreceiveVerdictRegexp = regexp.MustCompile(`(?i)\b(spf|dkim|dmarc)(?:[ \t]+check:[ \t]*|=)(pass|fail|softfail|neutral|none|temperror|permerror|policy)\b`)
+
+// checkreceiveVerdicts returns the first SPF, DKIM and DMARC results found in the
+// body of a reply from an echo service. The header section of the reply is
+// skipped, it contains our own Authentication-Results of the reply.
+func checkreceiveVerdicts(msg []byte) map[string]string {
+	if i := bytes.Index(msg, []byte("\r\n\r\n")); i >= 0 {
+		msg = msg[i+4:]
+	} else if i := bytes.Index(msg, []byte("\n\n")); i >= 0 {
+		msg = msg[i+2:]
+	}
+	verdicts := map[string]string{}
+	for _, m := range checkreceiveVerdictRegexp.FindAllSubmatch(msg, -1) {
+		mech := strings.ToLower(string(m[1]))
+		if _, ok := verdicts[mech]; !ok {
+			verdicts[mech] = strings.ToLower(string(m[2]))
+		}
+	}
+	return verdicts
+}
+
+// checkreceive sends a test message from an address of account accName to an
+// external echo address, and waits for the reply, writing progress and a scorecard to w. For use in the
+// running mox. It returns whether all checks passed.
+func checkreceive(ctx context.Context, log mlog.Log, w io.Writer, accName string, from, to smtp.Address, timeout time.Duration) (bool, error) {
+	acc, err := store.OpenAccount(log, accName)
+	if err != nil {
+		return false, fmt.Errorf("open account: %v", err)
+	}
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	buf := make([]byte, 8)
+	if _, err := cryptorand.Read(buf); err != nil {
+		return false, fmt.Errorf("generating token: %v", err)
+	}
+	token := fmt.Sprintf("%x", buf)
+	start := time.Now()
+
+	qid, messageID, err := checkreceiveSend(ctx, log, accName, from, to, token)
+	if err != nil {
+		return false, err
+	}
+	fmt.Fprintf(w, "Queued test message %d with subject token %s, waiting for delivery and reply.\n", qid, token)
+
+	var delivery, deliveryDetail string
+	var reply *store.Message
+	var replyData []byte
+	seenEvents := map[int64]bool{}
+	seenMessages := map[int64]bool{}
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+wait:
+	for {
+		events, err := admindb.MessageEventList(ctx, strconv.FormatInt(qid, 10))
+		if err != nil {
+			return false, fmt.Errorf("listing delivery events: %v", err)
+		}
+		for _, e := range events {
+			if seenEvents[e.ID] || e.QueueID != qid {
+				continue
+			}
+			seenEvents[e.ID] = true
+			switch e.Kind {
+			case admindb.EventAttempt, admindb.EventDelivered, admindb.EventFailed:
+				fmt.Fprintf(w, "%s %s %s %s %s\n", e.Time.Format("15:04:05"), e.Kind, e.Remote, e.Result, e.Detail)
+			}
+			switch e.Kind {
+			case admindb.EventDelivered:
+				delivery, deliveryDetail = "pass", fmt.Sprintf("delivered to %s: %s", e.Remote, e.Result)
+			case admindb.EventFailed:
+				delivery, deliveryDetail = "fail", fmt.Sprintf("%s %s", e.Result, e.Detail)
+			}
+		}
+
+		// Look for the reply in all mailboxes, including junk.
+		var msgs []store.Message
+		err = acc.DB.Read(ctx, func(tx *bstore.Tx) error {
+			q := bstore.QueryTx[store.Message](tx)
+			q.FilterEqual("Expunged", false)
+			q.FilterGreaterEqual("Received", start)
+			var err error
+			msgs, err = q.List()
+			return err
+		})
+		if err != nil {
+			return false, fmt.Errorf("listing new messages in account: %v", err)
+		}
+		for _, m := range msgs {
+			if seenMessages[m.ID] {
+				continue
+			}
+			seenMessages[m.ID] = true
+			data, err := io.ReadAll(io.LimitReader(acc.MessageReader(m), 1024*1024))
+			if err != nil {
+				return false, fmt.Errorf("reading message: %v", err)
+			}
+			if bytes.Contains(data, []byte(token)) {
+				reply = &m
+				replyData = data
+				fmt.Fprintf(w, "%s reply received\n", m.Received.Format("15:04:05"))
+				break
+			}
+		}
+		if reply != nil || delivery == "fail" {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-deadline.C:
+			fmt.Fprintf(w, "Timeout waiting for reply.\n")
+			break wait
+		case <-ticker.C:
+		}
+	}
+
+	type check struct {
+		name, result, detail string
+	}
+	var checks []check
+	if delivery == "" && reply != nil {
+		delivery, deliveryDetail = "pass", "reply received"
+	} else if delivery == "" {
+		delivery, deliveryDetail = "fail", fmt.Sprintf(`not delivered yet, see "mox message trace %s"`, messageID)
+	}
+	checks = append(checks, check{"delivery", delivery, deliveryDetail})
+
+	verdicts := map[string]string{}
+	if reply == nil {
+		checks = append(checks, check{"reply", "fail", "no reply received"})
+	} else {
+		var dkimDomains string
+		if len(reply.DKIMDomains) > 0 {
+			dkimDomains = strings.Join(reply.DKIMDomains, ",")
+		} else {
+			dkimDomains = "(none)"
+		}
+		detail := fmt.Sprintf("received after %s, verified by mox: spf %v, dkim %s, dmarc %v", reply.Received.Sub(start).Round(time.Second), reply.MailFromValidated, dkimDomains, reply.MsgFromValidated)
+		checks = append(checks, check{"reply", "pass", detail})
+		verdicts = checkreceiveVerdicts(replyData)
+	}
+	for _, mech := range []string{"spf", "dkim", "dmarc"} {
+		if v, ok := verdicts[mech]; ok {
+			result := "fail"
+			if v == "pass" {
+				result = "pass"
+			}
+			checks = append(checks, check{mech, result, "remote reported " + v})
+		} else {
+			checks = append(checks, check{mech, "unknown", "no result found in reply"})
+		}
+	}
+
+	pass := true
+	fmt.Fprintf(w, "\nScorecard:\n")
+	for _, c := range checks {
+		fmt.Fprintf(w, "%-10s %-8s %s\n", c.name, c.result, c.detail)
+		pass = pass && c.result == "pass"
+	}
+	return pass, nil
+}
+
+// checkreceiveSend composes, signs and queues the test message for checkreceive,
+// returning the queue ID and Message-ID.
+func checkreceiveSend(ctx context.Context, log mlog.Log, accName string, from, to smtp.Address, token string) (qid int64, messageID string, rerr error) {
+	smtputf8 := from.Localpart.IsInternational() || to.Localpart.IsInternational()
+
+	var b bytes.Buffer
+	xc := message.NewComposer(&b, 0, smtputf8)
+	defer func() {
+		x := recover()
+		if x == nil {
+			return
+		}
+		if err, ok := x.(error); ok && errors.Is(err, message.ErrCompose) {
+			rerr = err
+			return
+		}
+		panic(x)
+	}()
+
+	subject := "mox checkreceive " + token
+	xc.HeaderAddrs("From", []message.NameAddress{{Address: from}})
+	xc.HeaderAddrs("To", []message.NameAddress{{Address: to}})
+	xc.Subject(subject)
+	messageID = mox.MessageIDGen(xc.SMTPUTF8)
+	xc.Header("Message-Id", "<"+messageID+">")
+	xc.Header("Date", time.Now().Format(message.RFC5322Z))
+	xc.Header("User-Agent", "mox/"+moxvar.Version)
+	xc.Header("MIME-Version", "1.0")
+
+	text := fmt.Sprintf("Hi!\n\nThis is a test message sent with \"mox checkreceive\", to check that messages\nfrom %s are delivered and pass SPF, DKIM and DMARC checks, and that\nreplies are received.\n\nToken: %s\n\nCheers,\nmox\n", from.Domain, token)
+	textBody, ct, cte := xc.TextPart("plain", text)
+	xc.Header("Content-Type", ct)
+	xc.Header("Content-Transfer-Encoding", cte)
+	xc.Line()
+	xc.Write(textBody)
+	xc.Flush()
+	msg := b.Bytes()
+
+	dkimHeaders, err := mox.DKIMSign(ctx, log, from.Path(), xc.SMTPUTF8, msg)
+	if err != nil {
+		return 0, "", fmt.Errorf("dkim signing message: %v", err)
+	}
+
+	f, err := store.CreateMessageTemp(log, "checkreceive")
+	if err != nil {
+		return 0, "", fmt.Errorf("creating temporary message file: %v", err)
+	}
+	defer store.CloseRemoveTempFile(log, f, "checkreceive message for queue")
+	if _, err := f.Write(msg); err != nil {
+		return 0, "", fmt.Errorf("writing temporary message file: %v", err)
+	}
+
+	size := int64(len(dkimHeaders) + len(msg))
+	qml := []queue.Msg{queue.MakeMsg(from.Path(), to.Path(), xc.Has8bit, xc.SMTPUTF8, size, "<"+messageID+">", []byte(dkimHeaders), nil, time.Now(), subject)}
+	if err := queue.Add(ctx, log, accName, f, qml...); err != nil {
+		return 0, "", fmt.Errorf("adding message to queue: %v", err)
+	}
+	return qml[0].ID, messageID, nil
+}
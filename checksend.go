@@ -0,0 +1,413 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mjl-/adns"
+
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/mtasts"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/smtpclient"
+)
+
+func cmdChecksend(c *cmd) {
+	c.params = "[-from address] [-all] address"
+	c.help = `Simulate delivery of a message to an address, without sending a message.
+
+Follows the same steps as delivery from the queue: MX records are looked up, the
+MTA-STS policy of the recipient domain is fetched, and for each host the IPs and
+DANE TLSA records are looked up. A connection is made to the first host that
+can be reached, the SMTP session is initialized with EHLO and STARTTLS, and the
+TLS certificate is verified. Then MAIL FROM and RCPT TO are sent, to see if the
+recipient would be accepted, and the session is ended with QUIT before sending
+DATA.
+
+A transcript of the SMTP session is printed, along with the DNS and TLS
+details, to find out why messages to a domain are not delivered. The MTA-STS
+policy is always fetched, the queue may use a cached policy instead.
+
+With -all, each host of the recipient domain is checked, instead of stopping at
+the first host that accepts the recipient.
+
+Exits with status 1 if no host accepted the recipient.
+`
+	var from string
+	var all bool
+	c.flag.StringVar(&from, "from", "", "address for MAIL FROM, default postmaster at the hostname of this mox")
+	c.flag.BoolVar(&all, "all", false, "check all hosts instead of stopping at the first that accepts the recipient")
+	args := c.Parse()
+	if len(args) != 1 {
+		c.Usage()
+	}
+	mustLoadConfig()
+
+	rcpt, err := smtp.ParseAddress(args[0])
+	xcheckf(err, "parsing address")
+	mailFrom := smtp.Address{Localpart: "postmaster", Domain: mox.Conf.Static.HostnameDomain}
+	if from != "" {
+		mailFrom, err = smtp.ParseAddress(from)
+		xcheckf(err, "parsing -from address")
+	}
+
+	resolver := dns.StrictResolver{Pkg: "checksend"}
+	cs := checksender{
+		log:         c.log,
+		w:           os.Stdout,
+		resolver:    resolver,
+		dialer:      &net.Dialer{},
+		ourHostname: mox.Conf.Static.HostnameDomain,
+		localIPs:    mox.Conf.Static.SpecifiedSMTPListenIPs,
+		rootCAs:     mox.Conf.Static.TLS.CertPool,
+	}
+	if !cs.check(context.Background(), mailFrom, rcpt, all) {
+		os.Exit(1)
+	}
+}
+
+// checksender simulates delivery for "mox checksend".
+type checksender struct {
+	log         mlog.Log
+	w           io.Writer
+	resolver    dns.Resolver
+	dialer      smtpclient.Dialer
+	ourHostname dns.Domain
+	localIPs    []net.IP
+	rootCAs     *x509.CertPool // If nil, the system roots are used.
+}
+
+func (cs checksender) printf(format string, args ...any) {
+	fmt.Fprintf(cs.w, format+"\n", args...)
+}
+
+// check attempts delivery to the hosts of the domain of rcpt, up to the RCPT TO
+// command. It returns whether a host accepted the recipient.
+func (cs checksender) check(ctx context.Context, mailFrom, rcpt smtp.Address, all bool) bool {
+	origNextHop := rcpt.Domain
+	haveMX, origNextHopAuthentic, expandedNextHopAuthentic, expandedNextHop, hosts, permanent, err := smtpclient.GatherDestinations(ctx, cs.log.Logger, cs.resolver, dns.IPDomain{Domain: origNextHop})
+	if err != nil {
+		cs.printf("error: gathering destinations for %s: %v (permanent %v)", origNextHop, err, permanent)
+		return false
+	}
+	if expandedNextHop != origNextHop {
+		cs.printf("domain %s: followed cnames to %s", origNextHop, expandedNextHop)
+	}
+	var l []string
+	for _, h := range hosts {
+		l = append(l, h.String())
+	}
+	if haveMX {
+		cs.printf("mx hosts: %s (dnssec %v)", strings.Join(l, ", "), origNextHopAuthentic && expandedNextHopAuthentic)
+	} else {
+		cs.printf("no mx records, delivering to domain: %s (dnssec %v)", strings.Join(l, ", "), origNextHopAuthentic)
+	}
+
+	var policy *mtasts.Policy
+	_, policy, _, err = mtasts.Get(ctx, cs.log.Logger, cs.resolver, origNextHop)
+	if errors.Is(err, mtasts.ErrNoRecord) {
+		cs.printf("mta-sts: no policy")
+	} else if err != nil {
+		cs.printf("mta-sts: error getting policy: %v", err)
+	} else {
+		var mxl []string
+		for _, mx := range policy.MX {
+			mxl = append(mxl, mx.LogString())
+		}
+		cs.printf("mta-sts: policy with mode %s, mx %s", policy.Mode, strings.Join(mxl, ", "))
+	}
+	enforceMTASTS := policy != nil && policy.Mode == mtasts.ModeEnforce
+
+	var accepted bool
+	dialedIPs := map[string][]net.IP{}
+	for _, h := range hosts {
+		cs.printf("")
+		cs.printf("host %s:", h)
+		if policy != nil && policy.Mode != mtasts.ModeNone && !policy.Matches(h.Domain) {
+			if enforceMTASTS {
+				cs.printf("error: host does not match mta-sts policy in mode enforce, skipping")
+				continue
+			}
+			cs.printf("warning: host does not match mta-sts policy, but it is not enforced")
+		}
+		hctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+		ok := cs.checkHost(hctx, h, enforceMTASTS, haveMX, origNextHopAuthentic, origNextHop, expandedNextHopAuthentic, expandedNextHop, dialedIPs, mailFrom, rcpt)
+		cancel()
+		if ok {
+			accepted = true
+			if !all {
+				break
+			}
+		}
+	}
+	return accepted
+}
+
+// checkHost looks up IPs and TLSA records for host, connects and runs an SMTP
+// session up to RCPT TO, like deliverHost in the queue.
+func (cs checksender) checkHost(ctx context.Context, host dns.IPDomain, enforceMTASTS, haveMX, origNextHopAuthentic bool, origNextHop dns.Domain, expandedNextHopAuthentic bool, expandedNextHop dns.Domain, dialedIPs map[string][]net.IP, mailFrom, rcpt smtp.Address) bool {
+	tlsMode := smtpclient.TLSOpportunistic
+	tlsPKIX := false
+	if enforceMTASTS {
+		tlsMode = smtpclient.TLSRequiredStartTLS
+		tlsPKIX = true
+	}
+
+	authentic, expandedAuthentic, expandedHost, ips, _, err := smtpclient.GatherIPs(ctx, cs.log.Logger, cs.resolver, "ip", host, dialedIPs)
+	if err != nil {
+		cs.printf("error: resolving ips: %v", err)
+		return false
+	}
+	if host.IsDomain() && expandedHost != host.Domain {
+		cs.printf("followed cnames to %s", expandedHost)
+	}
+	var ipl []string
+	for _, ip := range ips {
+		ipl = append(ipl, ip.String())
+	}
+	cs.printf("ips: %s (dnssec %v)", strings.Join(ipl, ", "), authentic && expandedAuthentic)
+
+	var tlsHostnames []dns.Domain
+	if host.IsDomain() {
+		tlsHostnames = []dns.Domain{host.Domain}
+	}
+	var daneRecords []adns.TLSA
+	if authentic && origNextHopAuthentic && (!haveMX || expandedNextHopAuthentic) && host.IsDomain() {
+		daneRequired, records, tlsaBaseDomain, err := smtpclient.GatherTLSA(ctx, cs.log.Logger, cs.resolver, host.Domain, expandedNextHopAuthentic && expandedAuthentic, expandedHost)
+		if err != nil && daneRequired {
+			cs.printf("error: looking up dane tlsa records: %v", err)
+			return false
+		} else if !daneRequired {
+			cs.printf("dane: no tlsa records")
+		} else {
+			tlsMode = smtpclient.TLSRequiredStartTLS
+			if len(records) == 0 {
+				cs.printf("dane: only unusable tlsa records, starttls required without certificate verification")
+			} else {
+				var rl []string
+				for _, r := range records {
+					rl = append(rl, r.String())
+				}
+				cs.printf("dane: tlsa records: %s", strings.Join(rl, "; "))
+				daneRecords = records
+			}
+			tlsHostnames = smtpclient.GatherTLSANames(haveMX, expandedNextHopAuthentic, expandedAuthentic, origNextHop, expandedNextHop, host.Domain, tlsaBaseDomain)
+		}
+	} else {
+		cs.printf("dane: not used, destination not dnssec-signed")
+	}
+
+	switch {
+	case len(daneRecords) > 0:
+		cs.printf("tls: starttls required, certificate verified with dane")
+	case tlsPKIX:
+		cs.printf("tls: starttls required, certificate verified with pkix due to mta-sts")
+	case tlsMode == smtpclient.TLSRequiredStartTLS:
+		cs.printf("tls: starttls required, certificate not verified")
+	default:
+		cs.printf("tls: opportunistic starttls, certificate not verified")
+	}
+
+	conn, _, err := smtpclient.Dial(ctx, cs.log.Logger, cs.dialer, host, ips, 25, dialedIPs, cs.localIPs)
+	if err != nil {
+		cs.printf("error: connecting: %v", err)
+		return false
+	}
+	defer conn.Close()
+	cs.printf("connected to %s from %s", conn.RemoteAddr(), conn.LocalAddr())
+	cs.printf("")
+
+	var firstHost dns.Domain
+	var moreHosts []dns.Domain
+	if len(tlsHostnames) > 0 {
+		firstHost = tlsHostnames[0]
+		moreHosts = tlsHostnames[1:]
+	}
+	var verifiedRecord adns.TLSA
+	opts := smtpclient.Opts{
+		RootCAs:            cs.rootCAs,
+		DANERecords:        daneRecords,
+		DANEMoreHostnames:  moreHosts,
+		DANEVerifiedRecord: &verifiedRecord,
+	}
+	tlog := slog.New(checksendHandler{cs: cs})
+	sc, err := smtpclient.New(ctx, tlog, conn, tlsMode, tlsPKIX, cs.ourHostname, firstHost, opts)
+	if err != nil {
+		cs.printf("")
+		cs.printf("error: initializing smtp session: %v", err)
+		if errors.Is(err, smtpclient.ErrTLS) && tlsMode == smtpclient.TLSOpportunistic {
+			cs.printf("the queue would retry delivery to this host without tls")
+		}
+		return false
+	}
+
+	if tlsState := sc.TLSConnectionState(); tlsState != nil {
+		cs.printTLS(*tlsState, host, daneRecords, verifiedRecord)
+	} else {
+		cs.printf("# no tls, remote does not announce starttls")
+	}
+
+	smtpConn, err := sc.Conn()
+	if err != nil {
+		cs.printf("error: taking over smtp connection: %v", err)
+		return false
+	}
+	r := bufio.NewReader(smtpConn)
+	command := func(line string) (int, error) {
+		cs.printf("C: %s", line)
+		if err := smtpConn.SetDeadline(time.Now().Add(time.Minute)); err != nil {
+			return 0, err
+		}
+		if _, err := fmt.Fprintf(smtpConn, "%s\r\n", line); err != nil {
+			return 0, err
+		}
+		for {
+			s, err := r.ReadString('\n')
+			if err != nil {
+				return 0, err
+			}
+			s = strings.TrimRight(s, "\r\n")
+			cs.printf("S: %s", s)
+			if len(s) < 3 {
+				return 0, fmt.Errorf("malformed response line %q", s)
+			}
+			if len(s) == 3 || s[3] == ' ' {
+				return strconv.Atoi(s[:3])
+			}
+		}
+	}
+
+	var smtputf8 string
+	if sc.SupportsSMTPUTF8() && (!isASCII(rcpt.Pack(true)) || !isASCII(mailFrom.Pack(true))) {
+		smtputf8 = " SMTPUTF8"
+	}
+	code, err := command(fmt.Sprintf("MAIL FROM:<%s>%s", mailFrom.Pack(true), smtputf8))
+	if err != nil {
+		cs.printf("error: mail from: %v", err)
+		return false
+	}
+	if code/100 != 2 {
+		cs.printf("")
+		cs.printf("error: sender %s rejected", mailFrom)
+		_, err = command("QUIT")
+		cs.log.Check(err, "quit")
+		return false
+	}
+	code, err = command(fmt.Sprintf("RCPT TO:<%s>", rcpt.Pack(true)))
+	if err != nil {
+		cs.printf("error: rcpt to: %v", err)
+		return false
+	}
+	_, err = command("QUIT")
+	cs.log.Check(err, "quit")
+	cs.printf("")
+	if code/100 != 2 {
+		cs.printf("error: recipient %s rejected", rcpt)
+		return false
+	}
+	cs.printf("recipient accepted, no message sent")
+	return true
+}
+
+// printTLS prints the negotiated TLS parameters, the certificate of the remote
+// and the result of PKIX and DANE verification.
+func (cs checksender) printTLS(tlsState tls.ConnectionState, host dns.IPDomain, daneRecords []adns.TLSA, verifiedRecord adns.TLSA) {
+	cs.printf("# tls %s, ciphersuite %s", tls.VersionName(tlsState.Version), tls.CipherSuiteName(tlsState.CipherSuite))
+	for i, cert := range tlsState.PeerCertificates {
+		cs.printf("# certificate %d: subject %s, names %s, issuer %s, valid until %s", i, cert.Subject, strings.Join(cert.DNSNames, ","), cert.Issuer, cert.NotAfter.Format(time.RFC3339))
+	}
+	if len(tlsState.PeerCertificates) > 0 && host.IsDomain() {
+		intermediates := x509.NewCertPool()
+		for _, cert := range tlsState.PeerCertificates[1:] {
+			intermediates.AddCert(cert)
+		}
+		opts := x509.VerifyOptions{
+			DNSName:       host.Domain.ASCII,
+			Roots:         cs.rootCAs,
+			Intermediates: intermediates,
+		}
+		if _, err := tlsState.PeerCertificates[0].Verify(opts); err != nil {
+			cs.printf("# pkix verification for %s failed: %v", host.Domain, err)
+		} else {
+			cs.printf("# pkix verification for %s ok", host.Domain)
+		}
+	}
+	if len(daneRecords) > 0 {
+		cs.printf("# dane verified with tlsa record %s", verifiedRecord)
+	}
+}
+
+func isASCII(s string) bool {
+	for _, c := range s {
+		if c >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// checksendHandler is a slog handler that prints the protocol trace and debug
+// messages of smtpclient as part of the transcript of "mox checksend".
+type checksendHandler struct {
+	cs    checksender
+	attrs []slog.Attr
+}
+
+func (h checksendHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= mlog.LevelTrace
+}
+
+func (h checksendHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level < mlog.LevelDebug {
+		// Protocol trace, data can contain multiple lines.
+		prefix := ""
+		msg := r.Message
+		if s, ok := strings.CutPrefix(msg, "LC: "); ok {
+			prefix, msg = "C: ", s
+		} else if s, ok := strings.CutPrefix(msg, "RS: "); ok {
+			prefix, msg = "S: ", s
+		}
+		for _, line := range strings.Split(strings.TrimRight(msg, "\r\n"), "\r\n") {
+			h.cs.printf("%s%s", prefix, line)
+		}
+		return nil
+	}
+
+	var b strings.Builder
+	b.WriteString("# " + r.Message)
+	add := func(a slog.Attr) bool {
+		if a.Key != "pkg" && a.Key != "delta" {
+			fmt.Fprintf(&b, " %s=%v", a.Key, a.Value)
+		}
+		return true
+	}
+	for _, a := range h.attrs {
+		add(a)
+	}
+	r.Attrs(add)
+	h.cs.printf("%s", b.String())
+	return nil
+}
+
+func (h checksendHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	nh := h
+	nh.attrs = append(append([]slog.Attr{}, h.attrs...), attrs...)
+	return nh
+}
+
+func (h checksendHandler) WithGroup(name string) slog.Handler {
+	return h
+}
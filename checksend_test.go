@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/smtpclient"
+)
+
+func TestChecksend(t *testing.T) {
+	// Fake SMTP server, accepting only recipient mjl.
+	serve := func(conn net.Conn) {
+		defer conn.Close()
+		br := bufio.NewReader(conn)
+		fmt.Fprintf(conn, "220 mx.example ESMTP\r\n")
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd := strings.ToUpper(strings.TrimRight(line, "\r\n"))
+			switch {
+			case strings.HasPrefix(cmd, "EHLO "):
+				fmt.Fprintf(conn, "250-mx.example\r\n250 PIPELINING\r\n")
+			case strings.HasPrefix(cmd, "MAIL FROM:"):
+				fmt.Fprintf(conn, "250 ok\r\n")
+			case cmd == "RCPT TO:<MJL@EXAMPLE>":
+				fmt.Fprintf(conn, "250 ok\r\n")
+			case strings.HasPrefix(cmd, "RCPT TO:"):
+				fmt.Fprintf(conn, "550 5.1.1 no such user\r\n")
+			case cmd == "QUIT":
+				fmt.Fprintf(conn, "221 bye\r\n")
+				return
+			default:
+				fmt.Fprintf(conn, "500 unknown command\r\n")
+			}
+		}
+	}
+	smtpclient.DialHook = func(ctx context.Context, dialer smtpclient.Dialer, timeout time.Duration, addr string, laddr net.Addr) (net.Conn, error) {
+		client, server := net.Pipe()
+		go serve(server)
+		return client, nil
+	}
+	defer func() {
+		smtpclient.DialHook = nil
+	}()
+
+	var out strings.Builder
+	cs := checksender{
+		log: mlog.New("checksend", nil),
+		w:   &out,
+		resolver: dns.MockResolver{
+			MX: map[string][]*net.MX{"example.": {{Host: "mx.example.", Pref: 10}}},
+			A:  map[string][]string{"mx.example.": {"10.0.0.1"}},
+		},
+		ourHostname: dns.Domain{ASCII: "mox.example"},
+	}
+
+	xaddr := func(s string) smtp.Address {
+		t.Helper()
+		a, err := smtp.ParseAddress(s)
+		tcheck(t, err, "parse address")
+		return a
+	}
+
+	from := xaddr("postmaster@mox.example")
+	ok := cs.check(context.Background(), from, xaddr("mjl@example"), false)
+	if !ok {
+		t.Fatalf("recipient not accepted, output:\n%s", out.String())
+	}
+	for _, s := range []string{"mx hosts: mx.example", "mta-sts: no policy", "S: 220 mx.example ESMTP", "C: EHLO mox.example", "C: RCPT TO:<mjl@example>", "recipient accepted"} {
+		if !strings.Contains(out.String(), s) {
+			t.Fatalf("missing %q in output:\n%s", s, out.String())
+		}
+	}
+
+	out.Reset()
+	ok = cs.check(context.Background(), from, xaddr("other@example"), false)
+	if ok || !strings.Contains(out.String(), "S: 550 5.1.1 no such user") || !strings.Contains(out.String(), "error: recipient other@example rejected") {
+		t.Fatalf("expected rejected recipient, got ok %v, output:\n%s", ok, out.String())
+	}
+}
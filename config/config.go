@@ -14,6 +14,7 @@ import (
 	"github.com/mjl-/mox/dns"
 	"github.com/mjl-/mox/junk"
 	"github.com/mjl-/mox/mtasts"
+	"github.com/mjl-/mox/ocsp"
 	"github.com/mjl-/mox/smtp"
 )
 
@@ -36,7 +37,10 @@ func Port(port, fallback int) int {
 type Static struct {
 	DataDir          string            `sconf-doc:"NOTE: This config file is in 'sconf' format. Indent with tabs. Comments must be on their own line, they don't end a line. Do not escape or quote strings. Details: https://pkg.go.dev/github.com/mjl-/sconf.\n\n\nDirectory where all data is stored, e.g. queue, accounts and messages, ACME TLS certs/keys. If this is a relative path, it is relative to the directory of mox.conf."`
 	LogLevel         string            `sconf-doc:"Default log level, one of: error, info, debug, trace, traceauth, tracedata. Trace logs SMTP and IMAP protocol transcripts, with traceauth also messages with passwords, and tracedata on top of that also the full data exchanges (full messages), which can be a large amount of data."`
-	PackageLogLevels map[string]string `sconf:"optional" sconf-doc:"Overrides of log level per package (e.g. queue, smtpclient, smtpserver, imapserver, spf, dkim, dmarc, dmarcdb, autotls, junk, mtasts, tlsrpt)."`
+	PackageLogLevels map[string]string `sconf:"optional" sconf-doc:"Overrides of log level per package (e.g. queue, smtpclient, smtpserver, imapserver, spf, dkim, dmarc, dmarcdb, autotls, junk, mtasts, tlsrpt). Log levels can be changed while mox is running, in the admin web interface or with \"mox setloglevels\". On SIGHUP, mox resets the log levels to LogLevel and PackageLogLevels from this file."`
+	LogFormat        string            `sconf:"optional" sconf-doc:"Format of log lines written by \"mox serve\": logfmt (default) or json. With json, each line is a JSON object with fields time, level, msg, pkg, followed by the attributes of the line."`
+	LogSyslog        *LogSyslog        `sconf:"optional" sconf-doc:"Send log lines to syslog instead of standard error. Lines are sent with facility daemon and a severity based on the log level."`
+	LogRecent        int               `sconf:"optional" sconf-doc:"Number of recent log lines to keep in memory, for viewing in the admin web interface, where they can be filtered by account, message-id and connection id. Only lines passing the log levels are kept. Default 10000. Use -1 to keep no lines."`
 	User             string            `sconf:"optional" sconf-doc:"User to switch to after binding to all sockets as root. Default: mox. If the value is not a known user, it is parsed as integer and used as uid and gid."`
 	NoFixPermissions bool              `sconf:"optional" sconf-doc:"If true, do not automatically fix file permissions when starting up. By default, mox will ensure reasonable owner/permissions on the working, data and config directories (and files), and mox binary (if present)."`
 	Hostname         string            `sconf-doc:"Full hostname of system, e.g. mail.<domain>"`
@@ -56,7 +60,7 @@ type Static struct {
 	Postmaster        struct {
 		Account string
 		Mailbox string `sconf-doc:"E.g. Postmaster or Inbox."`
-	} `sconf-doc:"Destination for emails delivered to postmaster addresses: a plain 'postmaster' without domain, 'postmaster@<hostname>' (also for each listener with SMTP enabled), and as fallback for each domain without explicitly configured postmaster destination."`
+	} `sconf-doc:"Destination for emails delivered to postmaster addresses: a plain 'postmaster' without domain, 'postmaster@<hostname>' (also for each listener with SMTP enabled), and as fallback for each domain without explicitly configured postmaster destination. Also the fallback destination for the role addresses abuse@ and security@ of each domain. Abuse reports (ARF) received at role addresses are processed, adding recipients of spam complaints to the suppression list of the sending account, and listed in the admin web interface."`
 	HostTLSRPT struct {
 		Account   string `sconf-doc:"Account to deliver TLS reports to. Typically same account as for postmaster."`
 		Mailbox   string `sconf-doc:"Mailbox to deliver TLS reports to. Recommended value: TLSRPT."`
@@ -68,10 +72,42 @@ type Static struct {
 	DefaultMailboxes []string             `sconf:"optional" sconf-doc:"Deprecated in favor of InitialMailboxes. Mailboxes to create when adding an account. Inbox is always created. If no mailboxes are specified, the following are automatically created: Sent, Archive, Trash, Drafts and Junk."`
 	Transports       map[string]Transport `sconf:"optional" sconf-doc:"Transport are mechanisms for delivering messages. Transports can be referenced from Routes in accounts, domains and the global configuration. There is always an implicit/fallback delivery transport doing direct delivery with SMTP from the outgoing message queue. Transports are typically only configured when using smarthosts, i.e. when delivering through another SMTP server. Zero or one transport methods must be set in a transport, never multiple. When using an external party to send email for a domain, keep in mind you may have to add their IP address to your domain's SPF record, and possibly additional DKIM records."`
 	// Awkward naming of fields to get intended default behaviour for zero values.
-	NoOutgoingDMARCReports          bool  `sconf:"optional" sconf-doc:"Do not send DMARC reports (aggregate only). By default, aggregate reports on DMARC evaluations are sent to domains if their DMARC policy requests them. Reports are sent at whole hours, with a minimum of 1 hour and maximum of 24 hours, rounded up so a whole number of intervals cover 24 hours, aligned at whole days in UTC. Reports are sent from the postmaster@<mailhostname> address."`
-	NoOutgoingTLSReports            bool  `sconf:"optional" sconf-doc:"Do not send TLS reports. By default, reports about failed SMTP STARTTLS connections and related MTA-STS/DANE policies are sent to domains if their TLSRPT DNS record requests them. Reports covering a 24 hour UTC interval are sent daily. Reports are sent from the postmaster address of the configured domain the mailhostname is in. If there is no such domain, or it does not have DKIM configured, no reports are sent."`
-	OutgoingTLSReportsForAllSuccess bool  `sconf:"optional" sconf-doc:"Also send TLS reports if there were no SMTP STARTTLS connection failures. By default, reports are only sent when at least one failure occurred. If a report is sent, it does always include the successful connection counts as well."`
-	QuotaMessageSize                int64 `sconf:"optional" sconf-doc:"Default maximum total message size in bytes for each individual account, only applicable if greater than zero. Can be overridden per account. Attempting to add new messages to an account beyond its maximum total size will result in an error. Useful to prevent a single account from filling storage. The quota only applies to the email message files, not to any file system overhead and also not the message index database file (account for approximately 15% overhead)."`
+	NoOutgoingDMARCReports          bool   `sconf:"optional" sconf-doc:"Do not send DMARC reports, aggregate reports and failure reports configured in DMARCFailureReports. By default, aggregate reports on DMARC evaluations are sent to domains if their DMARC policy requests them. Reports are sent at whole hours, with a minimum of 1 hour and maximum of 24 hours, rounded up so a whole number of intervals cover 24 hours, aligned at whole days in UTC. Reports are sent from the postmaster@<mailhostname> address."`
+	NoOutgoingTLSReports            bool   `sconf:"optional" sconf-doc:"Do not send TLS reports. By default, reports about failed SMTP STARTTLS connections and related MTA-STS/DANE policies are sent to domains if their TLSRPT DNS record requests them. Reports covering a 24 hour UTC interval are sent daily. Reports are sent from the postmaster address of the configured domain the mailhostname is in. If there is no such domain, or it does not have DKIM configured, no reports are sent."`
+	OutgoingTLSReportsForAllSuccess bool   `sconf:"optional" sconf-doc:"Also send TLS reports if there were no SMTP STARTTLS connection failures. By default, reports are only sent when at least one failure occurred. If a report is sent, it does always include the successful connection counts as well."`
+	QuotaMessageSize                int64  `sconf:"optional" sconf-doc:"Default maximum total message size in bytes for each individual account, only applicable if greater than zero. Can be overridden per account. Attempting to add new messages to an account beyond its maximum total size will result in an error. Useful to prevent a single account from filling storage. The quota only applies to the email message files, not to any file system overhead and also not the message index database file (account for approximately 15% overhead)."`
+	CompressMessages                bool   `sconf:"optional" sconf-doc:"Store new message files of all accounts compressed (with zstd), in chunks so parts of messages can still be read efficiently. Messages are decompressed transparently when read, e.g. through IMAP and webmail. Text-heavy messages typically compress to a third of their size or less. Existing message files can be compressed with \"mox compressmessages\". Can also be enabled per account."`
+	DeduplicateMessages             bool   `sconf:"optional" sconf-doc:"Store identical message files once, e.g. for a message delivered to many accounts, by hard linking them to a file in the \"blobs\" directory in the data directory, named after the SHA-256 hash of the file contents. Blobs no longer used by any message are removed daily. Encrypted message files are unique per account and are not deduplicated. Quotas are still calculated with the full size of each message, so the usage of an account does not change when other accounts remove their copy of a message. Existing message files can be deduplicated with \"mox dedup\", which also shows the disk usage attributed to each account, with the size of shared files divided between the messages sharing them. Not supported on Windows."`
+	DeliveryDurability              string `sconf:"optional" sconf-doc:"Durability of incoming message deliveries, one of: full (default), relaxed. Concurrent deliveries to the same account are committed together in a single database transaction (group commit), e.g. for bursts of mailing list messages. With full, message files and their directories are synced to disk before the transaction is committed, so a delivery that is acknowledged to the sender survives a crash. With relaxed, message files are not explicitly synced, reducing disk writes further, but a crash or power loss shortly after delivery can leave messages in the database without (complete) message file, and senders won't retry. Such messages can be found with \"mox fsck\"."`
+	OIDC                            *OIDC  `sconf:"optional" sconf-doc:"Single sign-on with an OpenID Connect identity provider. If configured, users can log in to the webmail, account and admin web interfaces through the identity provider, and email clients can authenticate to IMAP and SMTP submission with OAuth 2.0 access tokens from the identity provider, with SASL mechanisms OAUTHBEARER and XOAUTH2. The email address in the tokens must be a login address of an account."`
+
+	WebmailRemoteContentProxy struct {
+		Enabled   bool          `sconf-doc:"Enable the proxy. When viewing HTML messages with external resources, the resources are fetched through the proxy."`
+		MaxSize   int64         `sconf:"optional" sconf-doc:"Maximum size in bytes of a single resource fetched through the proxy. Default 5MB."`
+		CacheSize int64         `sconf:"optional" sconf-doc:"Maximum total size in bytes of resources kept in memory, shared between accounts. Default 64MB."`
+		CacheTime time.Duration `sconf:"optional" sconf-doc:"How long fetched resources are kept in the cache. Default 24h."`
+	} `sconf:"optional" sconf-doc:"Proxy for remote content, such as images, in HTML messages viewed in webmail. Without the proxy, browsers fetch external resources directly, revealing the IP address of the reader, and that and when a message is read, to the sender. With the proxy, resources are fetched by mox, without cookies and referrer, and cached. Images that look like tracking pixels, with a size of at most 2x2 pixels, are not fetched. Only requests to public IP addresses are made."`
+	Replication         *Replication         `sconf:"optional" sconf-doc:"Replication of the data directory to standby instances, for failover when this machine is lost. Standbys run \"mox replication standby\", connect to a listener with ReplicationHTTPS enabled, and receive changes as they happen: changed blocks of databases, and new or rewritten message files. See \"mox replication status\" for the state of standbys. The configuration files are not replicated."`
+	Scrub               *Scrub               `sconf:"optional" sconf-doc:"Periodically read all message files of all accounts in the background, and compare their contents with the checksum stored when the message was delivered, to detect corruption of files on disk, such as bit rot on long-lived archives on consumer disks. Messages delivered before checksums were stored get their checksum recorded on their first scrub. Corrupt message files are logged, counted in the metrics, and reported to the postmaster. Corrupt message files can be restored automatically from copies of the data directory, such as backups or the data directory of a standby."`
+	SpamScan            *SpamScan            `sconf:"optional" sconf-doc:"External spam scanners, rspamd and/or SpamAssassin's spamd, to score incoming messages from senders without reputation, as an additional input besides the junk filter of the account. The scores of the scanners are scaled to a probability, so that the score a scanner considers spam (the required score) equals the junk threshold of the account, and are combined with the probability of the junk filter. For accounts without junk filter, the message is treated as junk if a scanner considers it spam."`
+	ContentBlocklists   *ContentBlocklists   `sconf:"optional" sconf-doc:"Check the contents of incoming messages from senders without reputation against block lists: the domains of URLs in text and HTML parts against URI block lists, and SHA-256 hashes of attachments against hash lists, e.g. malware hash feeds. A message with an attachment in a hash list is rejected, also for senders with a good reputation. A message with a URL listed in a URI block list is rejected like a message from an IP in a DNSBL. Delivered messages get an X-Mox-Content-Blocklists header with the results."`
+	QueueWorkers        *QueueWorkers        `sconf:"optional" sconf-doc:"Limits for concurrent delivery attempts of messages from the outgoing queue. Messages that are due are delivered round-robin per recipient domain, in order of the longest waiting message of each domain, so a domain with many deferred messages does not delay deliveries to other domains. Messages for the same domain that are delivered in a single attempt, e.g. a message with multiple recipients at the domain, count as a single delivery. Can be changed while running."`
+	DNSCache            *DNSCache            `sconf:"optional" sconf-doc:"Cache results of DNS lookups in memory for incoming and outgoing message delivery, e.g. of SPF, DKIM and DMARC records, DNSBLs and MX records. A burst of messages from the same sender otherwise causes the same lookups for each message. Concurrent identical lookups are combined into one. Names that don't exist are cached too (negative caching). Temporary errors are not cached. The TTLs of DNS records are not available to mox, so results are kept for a fixed duration, which should be lower than the TTLs of the records looked up. The hit rate is exported in metric mox_dns_cache_total."`
+	DNSBLScoring        *DNSBLScoring        `sconf:"optional" sconf-doc:"Score the IP address of incoming messages against multiple DNS block lists and allow lists, with a weight per list, instead of rejecting a message when its IP is in any of the DNSBLs of the SMTP listener. The lists are queried concurrently, and the weights of the lists that contain the IP are summed. Depending on thresholds, the message is rejected, greylisted or delivered to the Junk mailbox. Like the DNSBLs of SMTP listeners, lists are only consulted for messages without enough reputation, with content that looks acceptable. When configured, the DNSBLs of SMTP listeners are only used for monitoring the IPs we send from. Lookup results are cached, and the results per list are exported as metrics, so lists that stopped working or list too much can be spotted. Delivered messages get an X-Mox-DNSBL-Score header with the score and listings."`
+	Quarantine          *Quarantine          `sconf:"optional" sconf-doc:"Hold incoming messages that would be rejected for one of the configured reasons in a server-wide quarantine instead. Quarantined messages are accepted from the remote SMTP server, so the sender does not retry or get a bounce. Admins review the quarantine in the admin web interface, and release messages, delivering them to the intended mailbox, or remove them. Accounts can release their own quarantined messages in the account web interface. Messages in quarantine are removed automatically after the expiration period."`
+	SubmissionGuard     *SubmissionGuard     `sconf:"optional" sconf-doc:"Detect anomalies in messages submitted by accounts, through SMTP submission, webmail and the webapi, that indicate a compromised account, e.g. due to a stolen password, to prevent damage to the reputation of the IP addresses and domains of this server. Anomalies are a sudden spike in the number of recipients, a high rate of bounces (DSN messages received), and spammy content. Submissions from a network not used before by the account make detection stricter. When an anomaly is detected, the configured action is taken, and the postmaster is notified. Incidents are listed in the admin web interface, where throttles can be cleared."`
+	MessageEncryption   *MessageEncryption   `sconf:"optional" sconf-doc:"Encrypt message files of accounts at rest, e.g. to protect against disk snapshots of a rented server. New message files are encrypted with AES-256-GCM, with a key per account derived from the master key. Reading messages, e.g. through IMAP and webmail, decrypts transparently. Existing message files are not encrypted, but can still be read, they are encrypted when compressed with \"mox compressmessages\". Headers, message structure and addresses of messages in the account databases are encrypted with a key per account derived from the master key too, existing messages are upgraded when the account is opened. Message-IDs and base subjects, used for threading, and sender addresses, used for reputation, are stored as keyed hashes. Data needed for lookups, such as sender domains and IPs for reputation, mailbox names, and recipients of sent messages, and the contacts, junk filter and queue databases, and message files in the queue, are not encrypted; use file system encryption if those must be protected too. Once configured, the key must not be removed, messages in the account databases cannot be read without it. If the master key is lost, encrypted messages cannot be read anymore, so keep a copy of the key separate from backups of the data directory."`
+	MetricsSeries       *MetricsSeries       `sconf:"optional" sconf-doc:"Additional labeled series for the Prometheus metrics endpoint, with a label for destination domains, accounts or configured domains. The number of series grows with the number of domains and accounts, so these series are opt-in and limited. Metrics with labels for listeners, protocols and results only are always exported."`
+	Alerting            *Alerting            `sconf:"optional" sconf-doc:"Notify about operational problems by email and/or webhook: ACME certificates that are about to expire because renewal failed, a queue that grows beyond a threshold, IPs we send from that appear in a DNSBL, a nearly full disk, and many failed authentication attempts, e.g. due to password brute forcing. Alerts are also delivered to the postmaster mailbox. While a condition persists, the alert is repeated periodically. When the condition is resolved and occurs again, a new alert is sent. Conditions are kept in memory only, so a restart may cause alerts to be sent again."`
+	Tracing             *Tracing             `sconf:"optional" sconf-doc:"Record OpenTelemetry traces of incoming SMTP transactions, the delivery pipeline, including junk evaluation, and deliveries from the queue, including DNS lookups, connections and TLS handshakes, and export them to a collector with OTLP over HTTP. A message received over SMTP and queued for delivery is traced end-to-end: delivery attempts from the queue continue the trace of the SMTP transaction that queued the message."`
+	Node                *Node                `sconf:"optional" sconf-doc:"Role of this instance in a deployment of multiple mox instances sharing the same domains and accounts. Frontend nodes are MX hosts that receive incoming messages over SMTP, and forward them to a storage node that holds the accounts and message files, and serves IMAP, submission and the web interfaces. Frontend and storage nodes use identical domains.conf files, e.g. applied with \"mox config apply\". Frontend nodes check recipients and reject messages for unknown addresses, and do not evaluate junk or deliver to accounts themselves. The storage node evaluates forwarded messages with the IP address and EHLO hostname of the original sender, passed along with the XCLIENT SMTP extension. If absent, this instance handles everything itself."`
+	SecondaryMX         *SecondaryMX         `sconf:"optional" sconf-doc:"Accept messages as secondary (backup) MX for domains of which the accounts are on another mail server, the primary. Messages are accepted when the primary is unreachable, and the remote sends them to the next MX host in the DNS records of the domain. The messages are queued, and delivered to the primary when it is reachable again, through a transport. No accounts are created for these domains, and they must not be configured in domains.conf. Recipient addresses are checked against an address list, e.g. synchronized from the primary, so messages for unknown addresses are rejected during the SMTP transaction instead of causing bounces later. Add this server as MX host with a lower priority (higher preference value) than the primary in the DNS records of the domains. Messages are not checked for junk, the primary should do that."`
+	WebserverAccessLog  *WebserverAccessLog  `sconf:"optional" sconf-doc:"Write an access log for requests handled by WebHandlers from domains.conf, as JSON lines to a file, separate from the regular mox log. Each line has the time, handler name, remote IP, authenticated user, method, host, URL, status code, sizes, duration, user-agent and referrer, and the reason if a request was blocked by a rate limit or rule. The file is rotated when it reaches its maximum size."`
+	DMARCFailureReports *DMARCFailureReports `sconf:"optional" sconf-doc:"Send DMARC failure reports (forensic reports, ruf= in DMARC records) about incoming messages that fail DMARC checks, for the listed policy domains only. Failure reports contain details of individual messages, so they are only sent to domains that need them, e.g. partner domains debugging their DKIM and SPF setup, and the original message is redacted: only selected header fields are included, truncated, and by default no body. The report recipients must be in the ruf= field of the DMARC record of the domain, and the failure reporting options (fo=) are honored. Reports are sent from the postmaster@<mailhostname> address, DKIM-signed like aggregate reports. Reports are not sent when NoOutgoingDMARCReports is set."`
+	SMTPLineEndings     *SMTPLineEndings     `sconf:"optional" sconf-doc:"How to handle line ending anomalies in message data received by the SMTP server, for incoming messages and submissions. SMTP requires CRLF line endings. Messages with a bare CR or LF, or with a dot on a line with other line endings than CRLF, can be used for SMTP smuggling: a mail server earlier in the path may see additional messages, with forged From addresses, as part of a single message, while a mail server that interprets other line endings as end of data sees separate messages. Each anomaly can be rejected or only logged. Messages with anomalies are counted in metric mox_smtpserver_line_ending_anomaly_total, and per remote IP in the admin web interface, so operators can see which senders would be affected before rejecting. If absent, bare CRs and bad data endings are rejected, and bare LFs are logged."`
+	TLSFingerprintRules []TLSFingerprintRule `sconf:"optional" sconf-doc:"Rules for TLS clients of incoming SMTP, submission and IMAP connections, by their JA3 or JA4 fingerprint. Fingerprints are calculated from the TLS ClientHello message, and identify the TLS implementation and its configuration, not the client host. They are logged for each connection with TLS, and stored with incoming messages. Rules can block fingerprints of known botnets or abusive mail software, even when they connect from many different IPs. The first matching rule applies. Matches are counted in metric mox_tls_fingerprint_match_total."`
+	AutoBan             *AutoBan             `sconf:"optional" sconf-doc:"Automatically ban remote IPs, or their network, for a while after many failed authentication attempts or protocol abuses, like fail2ban but built in. Connections from banned networks to the SMTP submission, IMAP and HTTP listeners are closed immediately after they are accepted. SMTP for incoming delivery (port 25) is not affected unless SMTPDelivery is set. Web interface logins through a reverse proxy are counted for the IP in the X-Forwarded-For header, and refused while banned. Bans are kept in memory only, so they are lost when mox restarts. Current bans are listed in the admin web interface, where they can also be removed. Loopback IPs are never banned. Bans and refused connections are counted in metrics mox_autoban_total and mox_autoban_refused_total."`
+	PasswordPolicy      *PasswordPolicy      `sconf:"optional" sconf-doc:"Requirements for new account passwords, enforced when a password is changed through the account and admin web interfaces, the admin API and the command line. Passwords generated by quickstart and localserve are not checked. Accounts can have their own policy, replacing this one. Without a policy, passwords must be at least 8 characters. Refused passwords are counted in metric mox_password_policy_refused_total."`
 
 	// All IPs that were explicitly listened on for external SMTP. Only set when there
 	// are no unspecified external SMTP listeners and there is at most one for IPv4 and
@@ -87,6 +123,259 @@ type Static struct {
 	GID uint32 `sconf:"-" json:"-"`
 }
 
+// SpamScan configures external spam scanners.
+type SpamScan struct {
+	Rspamd       *Rspamd       `sconf:"optional" sconf-doc:"Score messages with rspamd, through its HTTP protocol."`
+	Spamd        *Spamd        `sconf:"optional" sconf-doc:"Score messages with SpamAssassin's spamd, through its SPAMC protocol."`
+	Timeout      time.Duration `sconf:"optional" sconf-doc:"Maximum time for scanning a message, per scanner. Default 15s."`
+	Weight       float64       `sconf:"optional" sconf-doc:"Weight of the scanners when combining with the probability of the junk filter of the account, between 0 and 1. If both scanners are configured, their probabilities are averaged first. Default 0.5. A weight of 1 uses only the scanners."`
+	IgnoreErrors bool          `sconf:"optional" sconf-doc:"If a scanner fails, e.g. due to a timeout, continue delivery without its score. By default, delivery fails with a temporary error, and the remote server will retry later."`
+}
+
+// Rspamd is the configuration for scanning messages with rspamd.
+type Rspamd struct {
+	URL      string `sconf-doc:"URL of the rspamd normal worker, e.g. http://localhost:11333. Messages are submitted to path /checkv2."`
+	Password string `sconf:"optional" sconf-doc:"Password to send with requests, if rspamd requires one."`
+}
+
+// Spamd is the configuration for scanning messages with SpamAssassin's spamd.
+type Spamd struct {
+	Address string `sconf-doc:"Address of spamd, either host:port, e.g. localhost:783, or the absolute path of a unix domain socket."`
+	User    string `sconf:"optional" sconf-doc:"User to scan messages as, for per-user SpamAssassin preferences. If empty, the name of the account the message is delivered to is used."`
+}
+
+// ContentBlocklists configures block lists for URLs and attachments in
+// incoming messages.
+type ContentBlocklists struct {
+	URIBLs    []string `sconf:"optional" sconf-doc:"Zones of URI block lists to look up the organizational domain of URLs in, e.g. multi.surbl.org, multi.uribl.com or dbl.spamhaus.org. Most block lists refuse queries through public DNS resolvers, so use a local resolver. At most 20 domains are looked up per message."`
+	HashFiles []string `sconf:"optional" sconf-doc:"Files with hexadecimal SHA-256 hashes of attachments to reject, one per line, e.g. from malware hash feeds. Empty lines and lines starting with # are ignored, as is text after the hash on a line. Files are read again when they change, e.g. after an update by a periodic download. Relative paths are relative to the directory of mox.conf."`
+
+	URIBLZones []dns.Domain `sconf:"-" json:"-"`
+}
+
+// DNSBLScoring configures scoring of incoming messages against DNS block and
+// allow lists.
+type DNSBLScoring struct {
+	Lists         []DNSBLScoreList `sconf-doc:"DNS block lists and allow lists to query."`
+	RejectScore   float64          `sconf:"optional" sconf-doc:"Reject messages with a score of at least this value. Default 10."`
+	GreylistScore float64          `sconf:"optional" sconf-doc:"Temporarily reject messages with a score of at least this value, but below RejectScore, when first seen from the network of the remote IP for the SMTP MAIL FROM and RCPT TO addresses. A retry after GreylistDelay is accepted. Legitimate mail servers retry, many spam senders do not. Zero disables greylisting."`
+	GreylistDelay time.Duration    `sconf:"optional" sconf-doc:"Minimum time before a retry of a greylisted message is accepted. Default 5m."`
+	TagScore      float64          `sconf:"optional" sconf-doc:"Deliver messages with a score of at least this value, but below GreylistScore and RejectScore, to the Junk mailbox. Zero disables tagging."`
+	CacheTTL      time.Duration    `sconf:"optional" sconf-doc:"How long lookup results are cached. Default 1h."`
+}
+
+// DNSBLScoreList is a DNS block list or allow list with its weight.
+type DNSBLScoreList struct {
+	Zone   string  `sconf-doc:"Zone of the list, e.g. zen.spamhaus.org, bl.spamcop.net or list.dnswl.org."`
+	Weight float64 `sconf-doc:"Added to the score when the IP is listed. Use a negative weight for allow lists (DNSWLs)."`
+
+	ZoneDomain dns.Domain `sconf:"-" json:"-"`
+}
+
+// MetricsSeries configures optional labeled metrics, with limits on their
+// cardinality.
+type MetricsSeries struct {
+	QueueDomains     int           `sconf:"optional" sconf-doc:"Number of destination domains with the most messages in the queue that get their own series in mox_queue_domain_messages. Messages for other domains are counted under domain \"other\". Zero only exports the \"other\" series."`
+	AccountStorage   bool          `sconf:"optional" sconf-doc:"Export storage used per account in mox_store_account_size_bytes and mox_store_account_messages, with one series per account each."`
+	DNSCheckInterval time.Duration `sconf:"optional" sconf-doc:"Periodically check the DNS records of all configured domains, like the check in the admin web interface, and export the number of errors and warnings per domain and check in mox_dnscheck_errors and mox_dnscheck_warnings, with about 15 series per domain each. Results of checks started from the admin web interface are exported too. Zero disables the checks and series. Minimum 1h."`
+}
+
+// QueueWorkers configures concurrency of deliveries from the queue.
+type QueueWorkers struct {
+	Workers   int `sconf:"optional" sconf-doc:"Maximum number of concurrent delivery attempts. Default 10."`
+	PerDomain int `sconf:"optional" sconf-doc:"Maximum number of concurrent delivery attempts to a single recipient domain. Some mail servers limit the number of connections per IP, so be careful with values higher than the default. Default 1."`
+}
+
+// DNSCache configures the in-memory cache of DNS lookups.
+type DNSCache struct {
+	TTL         time.Duration `sconf:"optional" sconf-doc:"How long successful lookups are kept, including those without records. Default 1m."`
+	NegativeTTL time.Duration `sconf:"optional" sconf-doc:"How long lookups of names that don't exist are kept. Default 30s."`
+	MaxEntries  int           `sconf:"optional" sconf-doc:"Maximum number of lookup results kept. Default 10000."`
+}
+
+// LogSyslog configures sending log lines to syslog.
+type LogSyslog struct {
+	Network string `sconf:"optional" sconf-doc:"Network to send log lines over: udp, tcp or unixgram. If empty, the local syslog daemon is used through its unix domain socket, e.g. /dev/log."`
+	Address string `sconf:"optional" sconf-doc:"Address to send log lines to, e.g. host:514 for udp and tcp, or a path for unixgram. Required if Network is set."`
+	Tag     string `sconf:"optional" sconf-doc:"Program name added to each line. Default mox."`
+}
+
+// Tracing configures exporting OpenTelemetry traces.
+type Tracing struct {
+	Endpoint    string            `sconf-doc:"URL of the OpenTelemetry collector to export spans to, with OTLP over HTTP in its JSON encoding, typically ending in /v1/traces, e.g. http://localhost:4318/v1/traces."`
+	Headers     map[string]string `sconf:"optional" sconf-doc:"Additional HTTP headers for requests to the endpoint, e.g. for authentication."`
+	ServiceName string            `sconf:"optional" sconf-doc:"Value for the service.name resource attribute of exported spans. Default mox."`
+	SampleRatio float64           `sconf:"optional" sconf-doc:"Fraction of new traces to record, between 0 and 1. Continued traces, e.g. for deliveries from the queue, follow the decision made when the trace started. Default 0 records all traces."`
+}
+
+// Node configures the role of this instance in a multi-node deployment.
+type Node struct {
+	Role             string   `sconf-doc:"Either frontend or storage. A frontend node only accepts incoming messages over SMTP and forwards them to the storage node. A storage node accepts forwarded messages from frontend nodes."`
+	StorageTransport string   `sconf:"optional" sconf-doc:"For frontend nodes, name of the transport to forward incoming messages to the storage node with. Must be a transport with method SMTP, Submission or Submissions, typically SMTP to a port of the storage node with an SMTP listener. Required for frontend nodes."`
+	FrontendIPs      []string `sconf:"optional" sconf-doc:"For storage nodes, IP addresses or networks in CIDR notation of frontend nodes. SMTP connections from these IPs can use the XCLIENT extension to pass the IP address and EHLO hostname of the original sender, which are then used for evaluating the message, e.g. for SPF, DNSBLs and reputation."`
+
+	FrontendNets []net.IPNet `sconf:"-" json:"-"`
+}
+
+// WebserverAccessLog configures the access log file for WebHandlers.
+type WebserverAccessLog struct {
+	File     string `sconf-doc:"Path of the log file. Relative paths are relative to the data directory. Rotated files get suffixes .1, .2, etc., with .1 the most recent."`
+	MaxSize  int64  `sconf:"optional" sconf-doc:"Size in bytes after which the file is rotated. Default 100MB."`
+	MaxFiles int    `sconf:"optional" sconf-doc:"Number of rotated files to keep, in addition to the current file. Default 5."`
+
+	Path string `sconf:"-" json:"-"` // File, relative to the data directory if not absolute.
+}
+
+// SecondaryMX configures accepting messages as secondary MX.
+type SecondaryMX struct {
+	Domains      map[string]SecondaryMXDomain `sconf-doc:"Domains to accept messages for as secondary MX, keyed by domain name."`
+	SyncInterval time.Duration                `sconf:"optional" sconf-doc:"Interval between fetching the address lists of domains with an AddressesURL. Default 1h."`
+}
+
+// SecondaryMXDomain is a domain we are secondary MX for.
+type SecondaryMXDomain struct {
+	Transport                   string   `sconf-doc:"Name of the transport to deliver messages to the primary with. Must be a transport with method SMTP, Submission or Submissions, typically SMTP with the host name of the primary MX."`
+	AddressesFile               string   `sconf:"optional" sconf-doc:"File with the email addresses to accept messages for, one per line. Empty lines and lines starting with # are ignored. A line with only @ and the domain accepts all addresses in the domain, e.g. for a catchall address at the primary. Localparts are compared case-insensitively. The file is read again when it changes. A mox primary can generate the list with \"mox config addresses\". Relative paths are relative to the directory of mox.conf. If absent, messages for all addresses in the domain are accepted, which is not recommended: messages for unknown addresses would be rejected by the primary only after they have been accepted, resulting in bounces to senders that may be forged."`
+	AddressesURL                string   `sconf:"optional" sconf-doc:"HTTP or HTTPS URL to periodically fetch the address list from, e.g. from a web server on the primary. The list is written to AddressesFile, which is required when AddressesURL is set. If fetching fails, the previous list stays in use."`
+	LocalpartCatchallSeparators []string `sconf:"optional" sconf-doc:"Separators in localparts, after which the remainder is ignored when checking addresses against the address list, e.g. + to accept user+tag@domain when user@domain is in the list. Typically the same as configured for the domain at the primary."`
+	XClient                     bool     `sconf:"optional" sconf-doc:"Pass the IP address and EHLO hostname of the original remote SMTP server to the primary with the XCLIENT SMTP extension, so the primary can evaluate the message, e.g. for SPF and DNSBLs, as if it was received directly. The primary must allow XCLIENT from the IPs of this server. For a mox primary, by configuring Node with role storage and these IPs in FrontendIPs."`
+
+	Domain dns.Domain `sconf:"-" json:"-"`
+}
+
+// SMTPLineEndings configures the handling of line ending anomalies in incoming
+// message data.
+type SMTPLineEndings struct {
+	BareCR     string `sconf:"optional" sconf-doc:"Action for a carriage return (CR) not followed by a newline (LF): reject (default) or log. With log, the message is accepted with the CR as is."`
+	BareLF     string `sconf:"optional" sconf-doc:"Action for a newline (LF) not preceded by a carriage return (CR): log (default) or reject. With log, the message is accepted and a CR is added before the LF. Some legitimate mail software sends bare LFs."`
+	BadDataEnd string `sconf:"optional" sconf-doc:"Action for a dot on a line with other line endings than CRLF, e.g. LF.LF or CRLF.LF, which some mail servers interpret as end of message data: reject (default) or log. With log, the message is accepted, and the sequence is treated as message data. Rejecting is recommended, these sequences are hardly used by legitimate senders."`
+}
+
+// AutoBan configures automatic banning of remote networks.
+type AutoBan struct {
+	AuthFailures   int           `sconf:"optional" sconf-doc:"Number of failed authentication attempts within Window after which a network is banned. Counted for IMAP, SMTP submission, web interface logins, the admin and web APIs, DAV, and web handlers with access control. Default 10. Use -1 to not ban for failed authentication."`
+	ProtocolErrors int           `sconf:"optional" sconf-doc:"Number of protocol abuses within Window after which a network is banned. Protocol abuses are connections that don't speak SMTP or IMAP, unknown commands, and SMTP connections closed after many failed recipients, e.g. when guessing addresses. Protocol abuse on SMTP for incoming delivery (port 25) is only counted if SMTPDelivery is set. Default 20. Use -1 to not ban for protocol abuse."`
+	Window         time.Duration `sconf:"optional" sconf-doc:"Period in which failures are counted. Default 10m."`
+	Duration       time.Duration `sconf:"optional" sconf-doc:"How long a ban lasts. Default 1h."`
+	IPv4Prefix     int           `sconf:"optional" sconf-doc:"Prefix length of the network of a remote IPv4 address for which failures are counted and that is banned. Default 32, only the IP itself."`
+	IPv6Prefix     int           `sconf:"optional" sconf-doc:"Prefix length of the network of a remote IPv6 address for which failures are counted and that is banned. Default 64, hosts typically have a /64 to themselves."`
+	Exempt         []string      `sconf:"optional" sconf-doc:"IPs or networks in CIDR notation that are never banned, e.g. of an office, monitoring system or frontend nodes."`
+	Command        string        `sconf:"optional" sconf-doc:"Shell command to run with sh -c when a ban starts and when it ends or is removed, e.g. to add the network to an nftables set or iptables chain so the firewall drops packets before they reach mox. Environment variables AUTOBAN_ACTION (ban or unban), AUTOBAN_NETWORK (e.g. 198.51.100.1/32 or 2001:db8:1:2::/64) and AUTOBAN_DURATION (seconds, for ban) are set. Failures of the command are logged, the ban in mox remains in place."`
+	SMTPDelivery   bool          `sconf:"optional" sconf-doc:"Also count protocol abuse on SMTP for incoming delivery (port 25), and close connections from banned networks to those listeners. Not enabled by default: legitimate mail servers can trigger protocol errors too, e.g. when delivering to many stale addresses of a mailing list, and a ban would delay or bounce their email."`
+
+	ExemptNets []net.IPNet `sconf:"-" json:"-"`
+}
+
+// PasswordPolicy holds requirements for new passwords.
+type PasswordPolicy struct {
+	MinLength      int      `sconf:"optional" sconf-doc:"Minimum number of characters. Default and lowest allowed value is 8."`
+	MinEntropy     int      `sconf:"optional" sconf-doc:"Minimum estimated strength in bits. The estimate is the number of characters times the bits per character for the classes of characters used (lower case, upper case, digits, other ASCII, non-ASCII), with repeated and sequential characters, like aaa or 123, counting as one. Only a rough estimate, dictionary words are not recognized, use DenyList and DenyListFile for common passwords. E.g. 50. Default 0, no minimum."`
+	DenyList       []string `sconf:"optional" sconf-doc:"Passwords that are refused, compared case-insensitively. The account name is always refused."`
+	DenyListFile   string   `sconf:"optional" sconf-doc:"File with passwords that are refused, one per line, compared case-insensitively, e.g. a list of commonly used passwords. Read each time a password is checked. Relative paths are relative to the directory of mox.conf."`
+	BreachCheck    bool     `sconf:"optional" sconf-doc:"Refuse passwords found in data breaches, by looking them up with the range API of Have I Been Pwned. Only the first 5 hexadecimal characters of the SHA-1 hash of the password are sent (k-anonymity), the password itself is not revealed. Responses are padded so their size does not reveal which hashes were returned. If the lookup fails, e.g. due to network errors, the password is accepted and an error logged."`
+	BreachCheckURL string   `sconf:"optional" sconf-doc:"Base URL of the range API, to which the 5 hash characters are appended. E.g. for a local mirror of the breached password hashes. Default https://api.pwnedpasswords.com/range/."`
+}
+
+// TLSFingerprintRule matches TLS clients by their fingerprint.
+type TLSFingerprintRule struct {
+	JA3       string   `sconf:"optional" sconf-doc:"JA3 fingerprint to match: an MD5 hash of 32 lower-case hexadecimal characters."`
+	JA4       string   `sconf:"optional" sconf-doc:"JA4 fingerprint to match, e.g. t13d1516h2_8daaf6152771_02713d6af862. Each of the three parts separated by underscores can be * to match any value, e.g. t12i0706h2_*_* for a combination of TLS version and number of ciphers and extensions. Exactly one of JA3 and JA4 must be set."`
+	Protocols []string `sconf:"optional" sconf-doc:"Protocols the rule applies to: smtp, submission, submissions, imap, imaps. If empty, the rule applies to all."`
+	Action    string   `sconf:"optional" sconf-doc:"Action for matching connections: reject (default) fails the TLS handshake, log only logs and counts the match, e.g. to see which connections a rule would block before rejecting."`
+	Comment   string   `sconf:"optional" sconf-doc:"Free-form comment, e.g. about the software or botnet the fingerprint belongs to. Included in log lines about matches."`
+}
+
+// DMARCFailureReports configures sending DMARC failure reports.
+type DMARCFailureReports struct {
+	Domains map[string]DMARCFailureReportsDomain `sconf-doc:"Policy domains to send failure reports to, keyed by domain name. The domain of a DMARC record, typically the organizational domain. Messages with a From address in subdomains match too."`
+}
+
+// DMARCFailureReportsDomain holds the redaction and rate limit settings for
+// failure reports to a policy domain.
+type DMARCFailureReportsDomain struct {
+	Headers       []string `sconf:"optional" sconf-doc:"Header fields of the original message to include in reports. Other header fields are left out. Default: From, Sender, Reply-To, To, Cc, Subject, Date, Message-ID, DKIM-Signature, Authentication-Results, Received-SPF."`
+	MaxHeaderSize int      `sconf:"optional" sconf-doc:"Maximum size in bytes of each included header field. Longer header fields are truncated. Default 1000."`
+	BodySize      int      `sconf:"optional" sconf-doc:"Include the first bytes of the message body, up to this size. Default 0, the body is not included."`
+	MaxPerHour    int      `sconf:"optional" sconf-doc:"Maximum number of failure reports sent to the domain per hour. Additional failures are not reported. Default 10."`
+
+	Domain dns.Domain `sconf:"-" json:"-"`
+}
+
+// Alerting configures notifications about operational problems.
+type Alerting struct {
+	Email           []string          `sconf:"optional" sconf-doc:"Email addresses to send alerts to, through the queue, from postmaster@<hostname>. Preferably addresses at another email provider, so alerts can be read when this server has problems."`
+	WebhookURL      string            `sconf:"optional" sconf-doc:"URL to send alerts to with an HTTP POST request, e.g. an incoming webhook of Slack or Mattermost, or an ntfy topic."`
+	WebhookFormat   string            `sconf:"optional" sconf-doc:"Format of webhook requests: json (default), a JSON object with fields Kind, Key, Subject, Text and Time; slack, a JSON object with field text, for Slack and compatible incoming webhooks; text, a plain text body with the subject in the Title header, for ntfy."`
+	WebhookHeaders  map[string]string `sconf:"optional" sconf-doc:"Additional HTTP headers for webhook requests, e.g. for authentication."`
+	CheckInterval   time.Duration     `sconf:"optional" sconf-doc:"Interval between checks for problems. Default 5m. Minimum 1m."`
+	RepeatInterval  time.Duration     `sconf:"optional" sconf-doc:"Interval for repeating alerts for problems that persist. Default 24h."`
+	CertExpiryDays  int               `sconf:"optional" sconf-doc:"Alert when an ACME certificate expires within this number of days, indicating renewal failed. Certificates are renewed 30 days before expiration by default. Default 14."`
+	QueueSize       int               `sconf:"optional" sconf-doc:"Alert when the number of messages in the outgoing queue is larger. Default 1000."`
+	DiskFreePercent int               `sconf:"optional" sconf-doc:"Alert when the free space of the file system of the data directory drops below this percentage. Not supported on all platforms. Default 10."`
+	AuthFailures    int               `sconf:"optional" sconf-doc:"Alert when there were more failed or rate limited authentication attempts since the previous check, over all protocols. Default 100."`
+}
+
+// Quarantine configures holding incoming messages for review.
+type Quarantine struct {
+	Reasons    []string      `sconf-doc:"Reasons for rejecting a message that cause it to be quarantined instead: junk-content, junk-content-strict, dns-blocklisted, uri-blocklisted, hash-blocklisted, spamscan, dmarc-policy, iprev, spamtrap."`
+	Expiration time.Duration `sconf:"optional" sconf-doc:"Period after which quarantined messages are removed. Default 720h (30 days)."`
+	Digest     bool          `sconf:"optional" sconf-doc:"Deliver a digest message at most once a day to accounts with newly quarantined messages, listing the messages with a link to the account web interface for releasing them."`
+}
+
+// SubmissionGuard configures detection of compromised accounts during submission.
+type SubmissionGuard struct {
+	Action               string        `sconf:"optional" sconf-doc:"Action when an anomaly is detected: alert (only notify the postmaster), throttle (refuse submissions by the account for ThrottleDuration) or freeze (disable logins to the account, hold its messages in the queue and close its sessions, until an admin enables logins again). Default throttle."`
+	ThrottleDuration     time.Duration `sconf:"optional" sconf-doc:"Duration of throttles. Default 1h."`
+	RecipientSpikeFactor float64       `sconf:"optional" sconf-doc:"An account has a recipient spike if the number of recipients in the past hour is more than this factor times its hourly average over the past week. Default 10."`
+	RecipientSpikeMin    int           `sconf:"optional" sconf-doc:"Minimum number of recipients in the past hour before it can be considered a spike. Default 50."`
+	BounceRate           float64       `sconf:"optional" sconf-doc:"Maximum fraction of recipients in the past 24 hours for which a bounce (DSN message) or spam complaint (abuse report) was received, between 0 and 1. Default 0.2."`
+	BounceMin            int           `sconf:"optional" sconf-doc:"Minimum number of recipients in the past 24 hours before the bounce rate is considered. Default 20."`
+	ContentCheck         bool          `sconf:"optional" sconf-doc:"Also classify the content of submitted messages, with the external spam scanners if configured in SpamScan, and with the junk filter of the account otherwise. A message classified as spam is an anomaly."`
+}
+
+// MessageEncryption configures the master key for encryption of message files and
+// the message index at rest.
+type MessageEncryption struct {
+	KeyFile    string `sconf:"optional" sconf-doc:"File with the base64-encoded 32 byte master key, e.g. generated with \"openssl rand -base64 32\". Relative paths are relative to the directory of mox.conf. Make sure only root and the mox user can read the file."`
+	KeyCommand string `sconf:"optional" sconf-doc:"Command to run through \"sh -c\" to obtain the master key when mox starts, e.g. for fetching the key from a key management service. The command must print the base64-encoded 32 byte key on standard output. Exactly one of KeyFile and KeyCommand must be set."`
+
+	Key []byte `sconf:"-" json:"-"` // Master key, set when parsing config.
+}
+
+// Scrub configures the background check of message files against their
+// checksums.
+type Scrub struct {
+	Interval       time.Duration `sconf:"optional" sconf-doc:"Time between starts of scrubs of all accounts. A scrub that was interrupted, e.g. by a restart, continues where it left off. Default 168h, one week."`
+	BytesPerSecond int64         `sconf:"optional" sconf-doc:"Maximum rate at which message files are read, in bytes per second, to keep the impact on regular operations low. Default 10MB per second."`
+	RestoreFrom    []string      `sconf:"optional" sconf-doc:"Directories with copies of the data directory to restore corrupt message files from, such as backup directories written by \"mox backup\" or the data directory of a standby. Message files are looked up at accounts/<account>/msg/ in these directories. The first copy that matches the checksum replaces the corrupt file. Relative paths are relative to the directory of mox.conf."`
+}
+
+// Replication configures replication to standby instances.
+type Replication struct {
+	TokenFile string `sconf-doc:"File with the secret token that standbys authenticate with, e.g. generated with \"openssl rand -base64 24\". At least 16 characters. Relative paths are relative to the directory of mox.conf. The same token must be passed to \"mox replication standby\"."`
+
+	Token string `sconf:"-" json:"-"` // Set when parsing config.
+}
+
+// OIDC is the configuration for an OpenID Connect identity provider.
+type OIDC struct {
+	Issuer       string   `sconf-doc:"URL of the issuer, e.g. https://login.example.com/realms/mail. The configuration of the identity provider is fetched from <Issuer>/.well-known/openid-configuration."`
+	ClientID     string   `sconf-doc:"Client ID of mox, as registered at the identity provider. Register the URLs of the web interfaces as redirect URIs, e.g. https://mail.example.com/webmail/, https://mail.example.com/ and https://mail.example.com/admin/."`
+	ClientSecret string   `sconf:"optional" sconf-doc:"Client secret, for a confidential client. If empty, mox is a public client. Logins always use PKCE."`
+	Scopes       []string `sconf:"optional" sconf-doc:"Additional scopes to request for web logins, besides openid, email and profile."`
+	Audiences    []string `sconf:"optional" sconf-doc:"Audiences accepted in access tokens for IMAP and SMTP submission. If empty, access tokens are not accepted. Should not include the client ID, which is the audience of ID tokens. Access tokens must be JWTs of type at+jwt (RFC 9068) signed by the issuer, or the identity provider must support token introspection, which requires a client secret."`
+	EmailClaim   string   `sconf:"optional" sconf-doc:"Claim in ID and access tokens with the email address of the user. Default: email. If claim email_verified is present and false, the token is not accepted."`
+	Admins       []string `sconf:"optional" sconf-doc:"Email addresses of users allowed to log in to the admin web interface through the identity provider."`
+}
+
+// EmailClaimEffective returns the configured email claim, or the default.
+func (c OIDC) EmailClaimEffective() string {
+	if c.EmailClaim != "" {
+		return c.EmailClaim
+	}
+	return "email"
+}
+
 // InitialMailboxes are mailboxes created for a new account.
 type InitialMailboxes struct {
 	SpecialUse SpecialUseMailboxes `sconf:"optional" sconf-doc:"Special-use roles to mailbox to create."`
@@ -111,22 +400,44 @@ type Dynamic struct {
 	WebDomainRedirects map[string]string  `sconf:"optional" sconf-doc:"Redirect all requests from domain (key) to domain (value). Always redirects to HTTPS. For plain HTTP redirects, use a WebHandler with a WebRedirect."`
 	WebHandlers        []WebHandler       `sconf:"optional" sconf-doc:"Handle webserver requests by serving static files, redirecting or reverse-proxying HTTP(s). The first matching WebHandler will handle the request. Built-in handlers, e.g. for account, admin, autoconfig and mta-sts always run first. If no handler matches, the response status code is file not found (404). If functionality you need is missng, simply forward the requests to an application that can provide the needed functionality."`
 	Routes             []Route            `sconf:"optional" sconf-doc:"Routes for delivering outgoing messages through the queue. Each delivery attempt evaluates account routes, domain routes and finally these global routes. The transport of the first matching route is used in the delivery attempt. If no routes match, which is the default with no configured routes, messages are delivered directly from the queue."`
+	AddressRewrites    []AddressRewrite   `sconf:"optional" sconf-doc:"Rules for rewriting email addresses, e.g. to map a legacy domain onto a new domain during a migration, or to masquerade addresses of an internal domain. Applied to recipient addresses of incoming messages and of messages submitted by accounts, and to sender addresses of submitted messages. For an address, the rules of its domain and these global rules are evaluated by descending priority, only the first matching rule is applied. Rewritten addresses are not rewritten again."`
 	MonitorDNSBLs      []string           `sconf:"optional" sconf-doc:"DNS blocklists to periodically check with if IPs we send from are present, without using them for checking incoming deliveries.. Also see DNSBLs in SMTP listeners in mox.conf, which specifies DNSBLs to use both for incoming deliveries and for checking our IPs against. Example DNSBLs: sbl.spamhaus.org, bl.spamcop.net."`
+	Version            int                `sconf:"optional" sconf-doc:"Schema version of this file. Files with an older or missing version are migrated automatically when mox starts, e.g. when fields were renamed. See \"mox config migrate\"."`
 
 	WebDNSDomainRedirects map[dns.Domain]dns.Domain `sconf:"-" json:"-"`
 	MonitorDNSBLZones     []dns.Domain              `sconf:"-"`
 }
 
 type ACME struct {
-	DirectoryURL           string                  `sconf-doc:"For letsencrypt, use https://acme-v02.api.letsencrypt.org/directory."`
+	DirectoryURL           string                  `sconf-doc:"For letsencrypt, use https://acme-v02.api.letsencrypt.org/directory. For ZeroSSL, which requires external account binding, use https://acme.zerossl.com/v2/DV90. For Buypass, use https://api.buypass.com/acme/directory."`
 	RenewBefore            time.Duration           `sconf:"optional" sconf-doc:"How long before expiration to renew the certificate. Default is 30 days."`
 	ContactEmail           string                  `sconf-doc:"Email address to register at ACME provider. The provider can email you when certificates are about to expire. If you configure an address for which email is delivered by this server, keep in mind that TLS misconfigurations could result in such notification emails not arriving."`
 	Port                   int                     `sconf:"optional" sconf-doc:"TLS port for ACME validation, 443 by default. You should only override this if you cannot listen on port 443 directly. ACME will make requests to port 443, so you'll have to add an external mechanism to get the connection here, e.g. by configuring port forwarding."`
-	IssuerDomainName       string                  `sconf:"optional" sconf-doc:"If set, used for suggested CAA DNS records, for restricting TLS certificate issuance to a Certificate Authority. If empty and DirectyURL is for Let's Encrypt, this value is set automatically to letsencrypt.org."`
+	IssuerDomainName       string                  `sconf:"optional" sconf-doc:"If set, used for suggested CAA DNS records, for restricting TLS certificate issuance to a Certificate Authority. If empty and DirectyURL is for Let's Encrypt, ZeroSSL or Buypass, this value is set automatically to letsencrypt.org, sectigo.com or buypass.com."`
 	ExternalAccountBinding *ExternalAccountBinding `sconf:"optional" sconf-doc:"ACME providers can require that a request for a new ACME account reference an existing non-ACME account known to the provider. External account binding references that account by a key id, and authorizes new ACME account requests by signing it with a key known both by the ACME client and ACME provider."`
 	// ../rfc/8555:2111
 
-	Manager *autotls.Manager `sconf:"-" json:"-"`
+	Fallback []string   `sconf:"optional" sconf-doc:"Names of other ACME providers to request certificates from, in order, when requesting a certificate from this provider fails, e.g. during an outage or after reaching a rate limit. After a failure, fallbacks are used for the host for 6 hours before trying this provider again. Keep in mind CAA DNS records must allow the certificate authorities of the fallbacks too."`
+	Hosts    []string   `sconf:"optional" sconf-doc:"Hosts to request certificates for from this ACME provider, instead of from the ACME provider configured for the listener, e.g. to use another certificate authority for some hosts. A host can only be listed for one ACME provider."`
+	DNS01    *ACMEDNS01 `sconf:"optional" sconf-doc:"Request certificates with DNS-01 challenges, by adding TXT records to DNS, instead of through TLS-ALPN-01 challenges on port 443. Required for wildcard certificates, and useful for hosts that can't be reached by the ACME provider. Hosts matching a listed name get the certificate for that name, for all listeners with this ACME provider."`
+
+	HostDomains []dns.Domain     `sconf:"-" json:"-"` // Parsed from Hosts.
+	Manager     *autotls.Manager `sconf:"-" json:"-"`
+}
+
+type ACMEDNS01 struct {
+	Hostnames        []string      `sconf-doc:"Names to request certificates for, with a certificate per name. A name can be a wildcard, e.g. *.example.com, which covers a single level of subdomains, but not example.com itself."`
+	RFC2136          *ACMERFC2136  `sconf:"optional" sconf-doc:"Change DNS records with DNS UPDATE messages, authenticated with a TSIG key. Either RFC2136 or Command must be set."`
+	Command          string        `sconf:"optional" sconf-doc:"Shell command to add or remove a TXT record, run with sh -c. Environment variables ACME_DNS01_ACTION (add or remove), ACME_DNS01_NAME (record name with trailing dot), ACME_DNS01_ZONE (organizational domain with trailing dot) and ACME_DNS01_VALUE (TXT record value) are set. The command must exit with status 0 on success."`
+	PropagationDelay time.Duration `sconf:"optional" sconf-doc:"Time to wait after adding the TXT record before asking the ACME provider to verify it, for the change to reach all authoritative name servers. Default 1m."`
+}
+
+type ACMERFC2136 struct {
+	Server         string `sconf-doc:"Address of DNS server to send updates to, as host:port, e.g. ns1.example.com:53. Updates are sent over TCP."`
+	Zone           string `sconf:"optional" sconf-doc:"Zone to update. If empty, the organizational domain of the name is used, e.g. example.com for _acme-challenge.mail.example.com."`
+	TSIGKeyName    string `sconf-doc:"Name of the TSIG key."`
+	TSIGAlgorithm  string `sconf:"optional" sconf-doc:"TSIG algorithm: hmac-sha256, hmac-sha384 or hmac-sha512. Default hmac-sha256."`
+	TSIGSecretFile string `sconf-doc:"File containing the base64-encoded TSIG secret. File is evaluated relative to the directory of mox.conf."`
 }
 
 type ExternalAccountBinding struct {
@@ -143,6 +454,8 @@ type Listener struct {
 
 	TLS                *TLS  `sconf:"optional" sconf-doc:"For SMTP/IMAP STARTTLS, direct TLS and HTTPS connections."`
 	SMTPMaxMessageSize int64 `sconf:"optional" sconf-doc:"Maximum size in bytes for incoming and outgoing messages. Default is 100MB."`
+	IMAPMaxMessageSize int64 `sconf:"optional" sconf-doc:"Maximum size in bytes for messages added with IMAP APPEND, announced to clients in the APPENDLIMIT capability. If set, LITERAL- is announced instead of LITERAL+, limiting non-synchronizing literals to 4096 bytes, so messages that are too large or would exceed the account quota are rejected before their data is transferred. Default is 0, for no limit other than the account quota."`
+	NoPlaintextAuth    bool  `sconf:"optional" sconf-doc:"Do not offer or accept authentication that sends the password to the server for IMAP and SMTP submission on this listener: IMAP LOGIN, and SASL PLAIN and LOGIN. Clients must authenticate with SCRAM, CRAM-MD5 or, with single sign-on configured, OAUTHBEARER/XOAUTH2. App passwords, and accounts with external authentication or two-factor authentication, can only be used with PLAIN and LOGIN, and cannot authenticate on this listener."`
 	SMTP               struct {
 		Enabled         bool
 		Port            int  `sconf:"optional" sconf-doc:"Default 25."`
@@ -152,7 +465,7 @@ type Listener struct {
 		// Reoriginated messages (such as messages sent to mailing list subscribers) should
 		// keep REQUIRETLS. ../rfc/8689:412
 
-		DNSBLs []string `sconf:"optional" sconf-doc:"Addresses of DNS block lists for incoming messages. Block lists are only consulted for connections/messages without enough reputation to make an accept/reject decision. This prevents sending IPs of all communications to the block list provider. If any of the listed DNSBLs contains a requested IP address, the message is rejected as spam. The DNSBLs are checked for healthiness before use, at most once per 4 hours. IPs we can send from are periodically checked for being in the configured DNSBLs. See MonitorDNSBLs in domains.conf to only monitor IPs we send from, without using those DNSBLs for incoming messages. Example DNSBLs: sbl.spamhaus.org, bl.spamcop.net. See https://www.spamhaus.org/sbl/ and https://www.spamcop.net/ for more information and terms of use."`
+		DNSBLs []string `sconf:"optional" sconf-doc:"Addresses of DNS block lists for incoming messages. Block lists are only consulted for connections/messages without enough reputation to make an accept/reject decision. This prevents sending IPs of all communications to the block list provider. If any of the listed DNSBLs contains a requested IP address, the message is rejected as spam. The DNSBLs are checked for healthiness before use, at most once per 4 hours. IPs we can send from are periodically checked for being in the configured DNSBLs. See MonitorDNSBLs in domains.conf to only monitor IPs we send from, without using those DNSBLs for incoming messages. With DNSBLScoring configured, these DNSBLs are not used for incoming messages. Example DNSBLs: sbl.spamhaus.org, bl.spamcop.net. See https://www.spamhaus.org/sbl/ and https://www.spamcop.net/ for more information and terms of use."`
 
 		FirstTimeSenderDelay *time.Duration `sconf:"optional" sconf-doc:"Delay before accepting a message from a first-time sender for the destination account. Default: 15s."`
 
@@ -176,18 +489,23 @@ type Listener struct {
 		Enabled bool
 		Port    int `sconf:"optional" sconf-doc:"Default 993."`
 	} `sconf:"optional" sconf-doc:"IMAP over TLS for reading email, by email applications. Requires a TLS config."`
-	AccountHTTP  WebService `sconf:"optional" sconf-doc:"Account web interface, for email users wanting to change their accounts, e.g. set new password, set new delivery rulesets. Default path is /."`
-	AccountHTTPS WebService `sconf:"optional" sconf-doc:"Account web interface listener like AccountHTTP, but for HTTPS. Requires a TLS config."`
-	AdminHTTP    WebService `sconf:"optional" sconf-doc:"Admin web interface, for managing domains, accounts, etc. Default path is /admin/. Preferably only enable on non-public IPs. Hint: use 'ssh -L 8080:localhost:80 you@yourmachine' and open http://localhost:8080/admin/, or set up a tunnel (e.g. WireGuard) and add its IP to the mox 'internal' listener."`
-	AdminHTTPS   WebService `sconf:"optional" sconf-doc:"Admin web interface listener like AdminHTTP, but for HTTPS. Requires a TLS config."`
-	WebmailHTTP  WebService `sconf:"optional" sconf-doc:"Webmail client, for reading email. Default path is /webmail/."`
-	WebmailHTTPS WebService `sconf:"optional" sconf-doc:"Webmail client, like WebmailHTTP, but for HTTPS. Requires a TLS config."`
-	WebAPIHTTP   WebService `sconf:"optional" sconf-doc:"Like WebAPIHTTP, but with plain HTTP, without TLS."`
-	WebAPIHTTPS  WebService `sconf:"optional" sconf-doc:"WebAPI, a simple HTTP/JSON-based API for email, with HTTPS (requires a TLS config). Default path is /webapi/."`
-	MetricsHTTP  struct {
+	AccountHTTP      WebService `sconf:"optional" sconf-doc:"Account web interface, for email users wanting to change their accounts, e.g. set new password, set new delivery rulesets. Default path is /."`
+	AccountHTTPS     WebService `sconf:"optional" sconf-doc:"Account web interface listener like AccountHTTP, but for HTTPS. Requires a TLS config."`
+	AdminHTTP        WebService `sconf:"optional" sconf-doc:"Admin web interface, for managing domains, accounts, etc. Default path is /admin/. Preferably only enable on non-public IPs. Hint: use 'ssh -L 8080:localhost:80 you@yourmachine' and open http://localhost:8080/admin/, or set up a tunnel (e.g. WireGuard) and add its IP to the mox 'internal' listener."`
+	AdminHTTPS       WebService `sconf:"optional" sconf-doc:"Admin web interface listener like AdminHTTP, but for HTTPS. Requires a TLS config."`
+	WebmailHTTP      WebService `sconf:"optional" sconf-doc:"Webmail client, for reading email. Default path is /webmail/."`
+	WebmailHTTPS     WebService `sconf:"optional" sconf-doc:"Webmail client, like WebmailHTTP, but for HTTPS. Requires a TLS config."`
+	WebAPIHTTP       WebService `sconf:"optional" sconf-doc:"Like WebAPIHTTP, but with plain HTTP, without TLS."`
+	WebAPIHTTPS      WebService `sconf:"optional" sconf-doc:"WebAPI, a simple HTTP/JSON-based API for email, with HTTPS (requires a TLS config). Default path is /webapi/."`
+	AdminAPIHTTP     WebService `sconf:"optional" sconf-doc:"Like AdminAPIHTTPS, but with plain HTTP, without TLS."`
+	AdminAPIHTTPS    WebService `sconf:"optional" sconf-doc:"Admin API, a versioned HTTP/JSON-based API for provisioning domains, accounts, addresses and aliases, authenticated with bearer tokens (see 'mox config apitoken add'), with HTTPS (requires a TLS config). Default path is /adminapi/. Preferably only enable on non-public IPs."`
+	DAVHTTP          WebService `sconf:"optional" sconf-doc:"Like DAVHTTPS, but with plain HTTP, without TLS."`
+	DAVHTTPS         WebService `sconf:"optional" sconf-doc:"CardDAV and CalDAV, for synchronizing the address book and calendar of an account with phones and desktop clients, with HTTPS (requires a TLS config). Clients authenticate with an email address and the account password. Default path is /dav/. Clients discover the path through /.well-known/carddav and /.well-known/caldav."`
+	ReplicationHTTPS WebService `sconf:"optional" sconf-doc:"Replication of the data directory to standby instances, see Replication in mox.conf and \"mox replication standby\", with HTTPS (requires a TLS config). Default path is /replication/. Preferably only enable on non-public IPs."`
+	MetricsHTTP      struct {
 		Enabled bool
 		Port    int `sconf:"optional" sconf-doc:"Default 8010."`
-	} `sconf:"optional" sconf-doc:"Serve prometheus metrics, for monitoring. You should not enable this on a public IP."`
+	} `sconf:"optional" sconf-doc:"Serve prometheus metrics at /metrics, for monitoring. Also serves /healthz and /readyz, for liveness and readiness probes of container orchestration and external monitoring, responding with status 200 if all checks pass and 503 otherwise. Liveness checks verify the listeners are bound and the queue is running. Readiness checks also verify accounts can be opened, ACME certificates do not expire within 7 days, and DNS self-checks pass. You should not enable this on a public IP."`
 	PprofHTTP struct {
 		Enabled bool
 		Port    int `sconf:"optional" sconf-doc:"Default 8011."`
@@ -202,6 +520,11 @@ type Listener struct {
 		Port    int  `sconf:"optional" sconf-doc:"TLS port, 443 by default. You should only override this if you cannot listen on port 443 directly. MTA-STS requests will be made to port 443, so you'll have to add an external mechanism to get the connection here, e.g. by configuring port forwarding."`
 		NonTLS  bool `sconf:"optional" sconf-doc:"If set, plain HTTP instead of HTTPS is spoken on the configured port. Can be useful when the mta-sts domain is reverse proxied."`
 	} `sconf:"optional" sconf-doc:"Serve MTA-STS policies describing SMTP TLS requirements. Requires a TLS config."`
+	WKDHTTPS struct {
+		Enabled bool
+		Port    int  `sconf:"optional" sconf-doc:"TLS port, 443 by default. You should only override this if you cannot listen on port 443 directly. WKD requests will be made to port 443, so you'll have to add an external mechanism to get the connection here, e.g. by configuring port forwarding."`
+		NonTLS  bool `sconf:"optional" sconf-doc:"If set, plain HTTP instead of HTTPS is spoken on the configured port. Can be useful when the openpgpkey domain is reverse proxied."`
+	} `sconf:"optional" sconf-doc:"Serve OpenPGP public keys of accounts through the Web Key Directory (WKD), at openpgpkey.<domain>. Only keys that users have marked for publication in webmail are served. Requires a TLS config."`
 	WebserverHTTP struct {
 		Enabled bool
 		Port    int `sconf:"optional" sconf-doc:"Port for plain HTTP (non-TLS) webserver."`
@@ -210,6 +533,7 @@ type Listener struct {
 		Enabled bool
 		Port    int `sconf:"optional" sconf-doc:"Port for HTTPS webserver."`
 	} `sconf:"optional" sconf-doc:"All configured WebHandlers will serve on an enabled listener. Either ACME must be configured, or for each WebHandler domain a TLS certificate must be configured."`
+	HTTP3 bool `sconf:"optional" sconf-doc:"Also serve HTTP/3 over QUIC on the UDP ports of the HTTPS listeners, e.g. for webmail, account, admin and webserver, and advertise it to browsers with an Alt-Svc header in HTTPS responses. Browsers switch to HTTP/3 for later requests, reducing latency on mobile networks with packet loss or changing connections. The UDP ports must be reachable, e.g. through firewalls."`
 }
 
 // WebService is an internal web interface: webmail, webaccount, webadmin, webapi.
@@ -249,7 +573,7 @@ type TransportSMTP struct {
 type SMTPAuth struct {
 	Username   string
 	Password   string
-	Mechanisms []string `sconf:"optional" sconf-doc:"Allowed authentication mechanisms. Defaults to SCRAM-SHA-256-PLUS, SCRAM-SHA-256, SCRAM-SHA-1-PLUS, SCRAM-SHA-1, CRAM-MD5. Not included by default: PLAIN. Specify the strongest mechanism known to be implemented by the server to prevent mechanism downgrade attacks."`
+	Mechanisms []string `sconf:"optional" sconf-doc:"Allowed authentication mechanisms. Defaults to SCRAM-SHA-512-PLUS, SCRAM-SHA-512, SCRAM-SHA-256-PLUS, SCRAM-SHA-256, SCRAM-SHA-1-PLUS, SCRAM-SHA-1, CRAM-MD5. Not included by default: PLAIN. Specify the strongest mechanism known to be implemented by the server to prevent mechanism downgrade attacks."`
 
 	EffectiveMechanisms []string `sconf:"-" json:"-"`
 }
@@ -283,29 +607,78 @@ type Domain struct {
 	TLSRPT                     *TLSRPT          `sconf:"optional" sconf-doc:"With TLSRPT a domain specifies in DNS where reports about encountered SMTP TLS behaviour should be sent. Useful for monitoring. Incoming TLS reports are automatically parsed, validated, added to metrics and stored in the reporting database for later display in the admin web pages."`
 	Routes                     []Route          `sconf:"optional" sconf-doc:"Routes for delivering outgoing messages through the queue. Each delivery attempt evaluates account routes, these domain routes and finally global routes. The transport of the first matching route is used in the delivery attempt. If no routes match, which is the default with no configured routes, messages are delivered directly from the queue."`
 	Aliases                    map[string]Alias `sconf:"optional" sconf-doc:"Aliases that cause messages to be delivered to one or more locally configured addresses. Keys are localparts (encoded, as they appear in email addresses)."`
+	RequireTOTP                bool             `sconf:"optional" sconf-doc:"Require two-factor authentication with TOTP codes for web logins of accounts that have this domain as their default domain. See RequireTOTP for accounts."`
+	Admins                     []string         `sconf:"optional" sconf-doc:"Login addresses of accounts that can manage this domain in the admin web interface, logging in with the email address and password of their account. Domain admins can manage accounts, addresses and aliases, DKIM keys and reporting addresses of their domains, and view DMARC and TLS reports for their domains. They cannot see other domains or server-wide settings. Addresses do not have to be in this domain, e.g. for a hosting customer managing multiple domains."`
+	SenderAllow                []string         `sconf:"optional" sconf-doc:"Senders, email addresses or domains as \"@domain\", whose messages to addresses in this domain skip junk filtering and DNS block list checks. Only applied to messages with a DMARC pass for the message From address, or an SPF pass for the SMTP MAIL FROM address. Parent domains of the sender match too. Entries in the sender lists of an account take precedence."`
+	SenderReject               []string         `sconf:"optional" sconf-doc:"Senders, email addresses or domains as \"@domain\", whose messages to addresses in this domain are rejected. Matched against the message From address and the SMTP MAIL FROM address."`
+	SenderJunk                 []string         `sconf:"optional" sconf-doc:"Senders, email addresses or domains as \"@domain\", whose messages to addresses in this domain are delivered to the Junk mailbox, marked as junk."`
+	Spamtraps                  []string         `sconf:"optional" sconf-doc:"Localparts of spamtrap addresses in this domain, addresses not used for real mail, e.g. published only where harvesters find them. Messages to spamtraps are accepted but never delivered. Their content trains the shared junk filter as junk, and the remote network and validated sender domain get a bad reputation, causing their messages to other addresses to be rejected for 30 days. Hits are listed in the admin web interface. Must not be an existing address or alias."`
+	Auth                       *DomainAuth      `sconf:"optional" sconf-doc:"Verify passwords for login addresses in this domain with an external authentication backend, LDAP or PAM, instead of the password stored in the account. App passwords, passkeys and two-factor authentication keep working as with local passwords. Authentication mechanisms that need a locally stored password, such as SCRAM and CRAM-MD5, cannot be used, email clients must use a mechanism like PLAIN that sends the password."`
+	Footer                     *Footer          `sconf:"optional" sconf-doc:"Footer, e.g. a legal disclaimer, added to the text of messages submitted by accounts with a message From address in this domain. A footer configured for the account takes precedence."`
+	AddressRewrites            []AddressRewrite `sconf:"optional" sconf-doc:"Rules for rewriting addresses in this domain, e.g. to masquerade internal addresses, or to map addresses onto another domain during a migration. Evaluated together with the global AddressRewrites, see there for details."`
 
 	Domain                  dns.Domain `sconf:"-"`
 	ClientSettingsDNSDomain dns.Domain `sconf:"-" json:"-"`
 
+	// Canonical senders from SenderAllow, SenderReject and SenderJunk, with value
+	// "allow", "reject" or "junk".
+	SenderActions map[string]string `sconf:"-" json:"-"`
+
+	// Canonical localparts from Spamtraps.
+	SpamtrapLocalparts map[smtp.Localpart]struct{} `sconf:"-" json:"-"`
+
 	// Set when DMARC and TLSRPT (when set) has an address with different domain (we're
 	// hosting the reporting), and there are no destination addresses configured for
 	// the domain. Disables some functionality related to hosting a domain.
 	ReportsOnly bool `sconf:"-" json:"-"`
 }
 
-// todo: allow external addresses as members of aliases. we would add messages for them to the queue for outgoing delivery. we should require an admin addresses to which delivery failures will be delivered (locally, and to use in smtp mail from, so dsns go there). also take care to evaluate smtputf8 (if external address requires utf8 and incoming transaction didn't).
+type DomainAuth struct {
+	LDAP          *LDAPAuth `sconf:"optional" sconf-doc:"Verify passwords by binding to an LDAP server, e.g. OpenLDAP or Active Directory."`
+	PAM           *PAMAuth  `sconf:"optional" sconf-doc:"Verify passwords with PAM, the pluggable authentication modules of Unix systems. Only available when mox is built with cgo and build tag \"pam\"."`
+	UsernameEmail bool      `sconf:"optional" sconf-doc:"Pass the full email address as username to the backend. By default, only the localpart of the login address is used."`
+	AutoProvision bool      `sconf:"optional" sconf-doc:"On the first successful login with an address in this domain that is not yet configured, create an account with that address. The account is named after the localpart, or after the full address if an account with the localpart as name already exists."`
+}
+
+type LDAPAuth struct {
+	URL            string `sconf-doc:"URL of the LDAP server, with scheme ldap or ldaps, e.g. ldaps://ldap.example.com. Default ports are 389 for ldap and 636 for ldaps. The TLS certificate is verified."`
+	StartTLS       bool   `sconf:"optional" sconf-doc:"For ldap URLs, switch the connection to TLS with the StartTLS operation before sending credentials. Required for ldap URLs, unless the LDAP server is on a loopback address or localhost."`
+	UserDNTemplate string `sconf:"optional" sconf-doc:"If set, bind directly with the DN for the user, with %s replaced by the username, e.g. uid=%s,ou=people,dc=example,dc=com. For Active Directory, a user principal name can be used instead of a DN, e.g. %s@example.com. If not set, the DN of the user is looked up with a search for Filter under BaseDN."`
+	BindDN         string `sconf:"optional" sconf-doc:"DN to bind with before searching for the user. If empty, the search is done anonymously."`
+	BindPassword   string `sconf:"optional" sconf-doc:"Password for BindDN."`
+	BaseDN         string `sconf:"optional" sconf-doc:"DN of the subtree to search for users, e.g. ou=people,dc=example,dc=com."`
+	Filter         string `sconf:"optional" sconf-doc:"Search filter for finding the user, with %s replaced by the escaped username. Exactly one entry must match. E.g. (&(objectClass=inetOrgPerson)(uid=%s)), or (&(objectClass=user)(sAMAccountName=%s)) for Active Directory."`
+}
+
+type PAMAuth struct {
+	Service string `sconf:"optional" sconf-doc:"PAM service name, selecting the configuration file in /etc/pam.d/. Default mox. Note that mox runs as an unprivileged user, PAM modules that require root privileges will not work."`
+}
+
+// ServiceEffective returns the configured service, or the default.
+func (p PAMAuth) ServiceEffective() string {
+	if p.Service != "" {
+		return p.Service
+	}
+	return "mox"
+}
+
 // todo: as alternative to PostPublic, allow specifying a list of addresses (dmarc-like verified) that are (the only addresses) allowed to post to the list. if msgfrom is an external address, require a valid dkim signature to prevent dmarc-policy-related issues when delivering to remote members.
 // todo: add option to require messages sent to an alias have that alias as From or Reply-To address?
 
 type Alias struct {
-	Addresses    []string `sconf-doc:"Expanded addresses to deliver to. These must currently be of addresses of local accounts. To prevent duplicate messages, a member address that is also an explicit recipient in the SMTP transaction will only have the message delivered once. If the address in the message From header is a member, that member also won't receive the message."`
+	Addresses    []string `sconf-doc:"Expanded addresses to deliver to. Addresses in configured domains must be addresses of local accounts. Addresses in other domains are external members, messages for them are added to the queue for delivery, with the owner address as SMTP MAIL FROM. At least one member must be a local address, the junk filtering of its account is used to decide whether to accept a message. To prevent duplicate messages, a member address that is also an explicit recipient in the SMTP transaction will only have the message delivered once. If the address in the message From header is a member, that member also won't receive the message."`
 	PostPublic   bool     `sconf:"optional" sconf-doc:"If true, anyone can send messages to the list. Otherwise only members, based on message From address, which is assumed to be DMARC-like-verified."`
 	ListMembers  bool     `sconf:"optional" sconf-doc:"If true, members can see addresses of members."`
 	AllowMsgFrom bool     `sconf:"optional" sconf-doc:"If true, members are allowed to send messages with this alias address in the message From header."`
-
-	LocalpartStr    string         `sconf:"-"` // In encoded form.
-	Domain          dns.Domain     `sconf:"-"`
-	ParsedAddresses []AliasAddress `sconf:"-"` // Matches addresses.
+	Owner        string   `sconf:"optional" sconf-doc:"Account that created this alias through the account web interface, and that can remove it. Aliases created by an account count towards its MaxAliases limit."`
+	OwnerAddress string   `sconf:"optional" sconf-doc:"Local address responsible for the alias. Used as SMTP MAIL FROM for messages forwarded to external members, so delivery failure notifications (DSNs) are delivered to its account. Also receives requests to approve held messages for moderated aliases. If empty, the postmaster address of the domain of the alias is used, with notifications delivered to the postmaster account."`
+	Moderated    bool     `sconf:"optional" sconf-doc:"If true and PostPublic is false, messages from non-members are not rejected but held for approval. The owner address is sent a message with a secret link to a page where the message can be approved or rejected. Held messages that are not approved within a week are removed."`
+
+	LocalpartStr      string         `sconf:"-"` // In encoded form.
+	Domain            dns.Domain     `sconf:"-"`
+	ParsedAddresses   []AliasAddress `sconf:"-"` // Matches addresses of local members.
+	ExternalAddresses []smtp.Address `sconf:"-"` // External members, in domains not configured.
+	ParsedOwner       AliasAddress   `sconf:"-"` // Zero if OwnerAddress is empty.
 }
 
 type AliasAddress struct {
@@ -329,6 +702,8 @@ type MTASTS struct {
 	Mode     mtasts.Mode   `sconf-doc:"If set to \"enforce\", a remote SMTP server will not deliver email to us if it cannot make a WebPKI-verified SMTP STARTTLS connection. In mode \"testing\", deliveries can be done without verified TLS, but errors will be reported through TLS reporting. In mode \"none\", verified TLS is not required, used for phasing out an MTA-STS policy."`
 	MaxAge   time.Duration `sconf-doc:"How long a remote mail server is allowed to cache a policy. Typically 1 or several weeks."`
 	MX       []string      `sconf:"optional" sconf-doc:"List of server names allowed for SMTP. If empty, the configured hostname is set. Host names can contain a wildcard (*) as a leading label (matching a single label, e.g. *.example matches host.example, not sub.host.example)."`
+
+	EnforceAfter time.Duration `sconf:"optional" sconf-doc:"If set and Mode is \"testing\", the policy is automatically changed to mode \"enforce\" with a new policy ID when TLS reports (TLSRPT) for the domain have not shown failures for this period, e.g. 336h for two weeks. At least one TLS report without failures must have been received. The period starts when the policy with its current ID is first seen in mode testing, and again after each TLS report with failures. After promotion, the DNS record must be updated with the new policy ID."`
 	// todo: parse mx as valid mtasts.Policy.MX, with dns.ParseDomain but taking wildcard into account
 }
 
@@ -420,14 +795,20 @@ type Account struct {
 	Destinations                 map[string]Destination `sconf:"optional" sconf-doc:"Destinations, keys are email addresses (with IDNA domains). All destinations are allowed for logging in with IMAP/SMTP/webmail. If no destinations are configured, the account can not login. If the address is of the form '@domain', i.e. with localpart missing, it serves as a catchall for the domain, matching all messages that are not explicitly configured. Deprecated behaviour: If the address is not a full address but a localpart, it is combined with Domain to form a full address."`
 	SubjectPass                  SubjectPass            `sconf:"optional" sconf-doc:"If configured, messages classified as weakly spam are rejected with instructions to retry delivery, but this time with a signed token added to the subject. During the next delivery attempt, the signed token will bypass the spam filter. Messages with a clear spam signal, such as a known bad reputation, are rejected/delayed without a signed token."`
 	QuotaMessageSize             int64                  `sconf:"optional" sconf-doc:"Default maximum total message size in bytes for the account, overriding any globally configured default maximum size if non-zero. A negative value can be used to have no limit in case there is a limit by default. Attempting to add new messages to an account beyond its maximum total size will result in an error. Useful to prevent a single account from filling storage."`
+	CompressMessages             bool                   `sconf:"optional" sconf-doc:"Store new message files of this account compressed, also see CompressMessages in mox.conf for enabling compression for all accounts."`
 	RejectsMailbox               string                 `sconf:"optional" sconf-doc:"Mail that looks like spam will be rejected, but a copy can be stored temporarily in a mailbox, e.g. Rejects. If mail isn't coming in when you expect, you can look there. The mail still isn't accepted, so the remote mail server may retry (hopefully, if legitimate), or give up (hopefully, if indeed a spammer). Messages are automatically removed from this mailbox, so do not set it to a mailbox that has messages you want to keep."`
 	KeepRejects                  bool                   `sconf:"optional" sconf-doc:"Don't automatically delete mail in the RejectsMailbox listed above. This can be useful, e.g. for future spam training. It can also cause storage to fill up."`
 	AutomaticJunkFlags           AutomaticJunkFlags     `sconf:"optional" sconf-doc:"Automatically set $Junk and $NotJunk flags based on mailbox messages are delivered/moved/copied to. Email clients typically have too limited functionality to conveniently set these flags, especially $NonJunk, but they can all move messages to a different mailbox, so this helps them."`
 	JunkFilter                   *JunkFilter            `sconf:"optional" sconf-doc:"Content-based filtering, using the junk-status of individual messages to rank words in such messages as spam or ham. It is recommended you always set the applicable (non)-junk status on messages, and that you do not empty your Trash because those messages contain valuable ham/spam training information."` // todo: sane defaults for junkfilter
 	MaxOutgoingMessagesPerDay    int                    `sconf:"optional" sconf-doc:"Maximum number of outgoing messages for this account in a 24 hour window. This limits the damage to recipients and the reputation of this mail server in case of account compromise. Default 1000."`
 	MaxFirstTimeRecipientsPerDay int                    `sconf:"optional" sconf-doc:"Maximum number of first-time recipients in outgoing messages for this account in a 24 hour window. This limits the damage to recipients and the reputation of this mail server in case of account compromise. Default 200."`
+	MaxAliases                   int                    `sconf:"optional" sconf-doc:"Maximum number of aliases the account can create itself through the account web interface, in domains of its addresses and with its own addresses as members. Default 0, account can not create aliases."`
 	NoFirstTimeSenderDelay       bool                   `sconf:"optional" sconf-doc:"Do not apply a delay to SMTP connections before accepting an incoming message from a first-time sender. Can be useful for accounts that sends automated responses and want instant replies."`
+	RequireTOTP                  bool                   `sconf:"optional" sconf-doc:"Require two-factor authentication with TOTP codes (from an authenticator app) for logging in to the webmail and account web interfaces. Until two-factor authentication is set up, only logins to the account web interface are allowed, for setting it up. With two-factor authentication enabled, the account password can no longer be used for IMAP and SMTP submission, app passwords must be used instead."`
+	LoginDisabled                string                 `sconf:"optional" sconf-doc:"If non-empty, login attempts on all protocols (e.g. SMTP/IMAP, web interfaces) are rejected with this error message, and existing web sessions can no longer be used. Set while deletion of the account is pending. Incoming deliveries for addresses of this account are still accepted."`
 	Routes                       []Route                `sconf:"optional" sconf-doc:"Routes for delivering outgoing messages through the queue. Each delivery attempt evaluates these account routes, domain routes and finally global routes. The transport of the first matching route is used in the delivery attempt. If no routes match, which is the default with no configured routes, messages are delivered directly from the queue."`
+	Footer                       *Footer                `sconf:"optional" sconf-doc:"Footer, e.g. a legal disclaimer, added to the text of messages submitted by this account. Takes precedence over a footer configured for the domain of the message From address."`
+	PasswordPolicy               *PasswordPolicy        `sconf:"optional" sconf-doc:"Requirements for new passwords of this account, replacing the PasswordPolicy from mox.conf."`
 
 	DNSDomain                  dns.Domain     `sconf:"-"` // Parsed form of Domain.
 	JunkMailbox                *regexp.Regexp `sconf:"-" json:"-"`
@@ -437,6 +818,22 @@ type Account struct {
 	Aliases                    []AddressAlias `sconf:"-"`
 }
 
+type Footer struct {
+	Text        []string `sconf:"optional" sconf-doc:"Lines of the plain text footer, added after an empty line at the end of text/plain parts. If absent, text/plain parts are left unchanged."`
+	HTML        []string `sconf:"optional" sconf-doc:"Lines of the HTML footer, inserted before the closing body tag of text/html parts, or added at the end if absent. If absent, the plain text footer is HTML-escaped and used instead."`
+	SkipReplies bool     `sconf:"optional" sconf-doc:"Don't add the footer to replies, i.e. messages with an In-Reply-To or References header. Messages earlier in a thread typically already have the footer."`
+}
+
+type AddressRewrite struct {
+	AddressRegexp string `sconf-doc:"Regular expression matched against the full email address, e.g. ^(.+)@internal\\.example$. The domain is in lower case, with unicode characters for internationalized domains. Matching is case-insensitive. Addresses that do not match are not changed."`
+	Replace       string `sconf-doc:"Replacement for the matched address, e.g. $1@example.org. Implemented with Go's Regexp.ReplaceAllString: $1 is replaced with the text of the first submatch, etc. The result must be a valid email address."`
+	Priority      int    `sconf:"optional" sconf-doc:"Rules with a higher priority are evaluated first. For the same priority, rules of the domain of the address are evaluated before global rules, then in configured order."`
+	Sender        bool   `sconf:"optional" sconf-doc:"Rewrite sender addresses of messages submitted by accounts: the SMTP MAIL FROM address and the address in the message From header. The account must be allowed to send with the original address. The rewritten address must be in a configured domain, messages are DKIM-signed for that domain."`
+	Recipient     bool   `sconf:"optional" sconf-doc:"Rewrite recipient addresses, i.e. SMTP RCPT TO, of incoming messages and of messages submitted by accounts. Incoming messages are delivered to the rewritten address, submitted messages are sent to it. Message headers are not changed."`
+
+	Address *regexp.Regexp `sconf:"-" json:"-"`
+}
+
 type AddressAlias struct {
 	SubscriptionAddress string
 	Alias               Alias    // Without members.
@@ -444,7 +841,10 @@ type AddressAlias struct {
 }
 
 type JunkFilter struct {
-	Threshold float64 `sconf-doc:"Approximate spaminess score between 0 and 1 above which emails are rejected as spam. Each delivery attempt adds a little noise to make it slightly harder for spammers to identify words that strongly indicate non-spaminess and use it to bypass the filter. E.g. 0.95."`
+	Threshold         float64 `sconf-doc:"Approximate spaminess score between 0 and 1 above which emails are rejected as spam. Each delivery attempt adds a little noise to make it slightly harder for spammers to identify words that strongly indicate non-spaminess and use it to bypass the filter. E.g. 0.95."`
+	SharedWeight      float64 `sconf:"optional" sconf-doc:"If larger than 0, incoming messages are also classified with the shared junk filter, which is trained with messages from all accounts that have ContributeShared set. The spam probabilities of the junk filter of this account and the shared junk filter are combined, with this weight for the shared junk filter, between 0 and 1. If the junk filter of this account does not recognize any significant words, e.g. for a new account without history, the probability of the shared junk filter is used as is. E.g. 0.3."`
+	ContributeShared  bool    `sconf:"optional" sconf-doc:"Also train the shared junk filter with messages of this account that are marked as junk or nonjunk. Only enable for accounts whose junk/nonjunk classifications can be trusted."`
+	DelayFlagTraining bool    `sconf:"optional" sconf-doc:"By default, the junk filter is retrained immediately when the junk/nonjunk flags of messages change, e.g. when an email client moves messages to/from the Junk mailbox (with AutomaticJunkFlags), or sets/clears the $Junk or $NotJunk flags. If set, retraining is delayed: a daily background job retrains the backlog of messages with changed flags. Can be useful for accounts where flags of many messages are changed at a time. The backlog can also be retrained with \"mox retrain -backlog\"."`
 	junk.Params
 }
 
@@ -510,26 +910,41 @@ type KeyCert struct {
 }
 
 type TLS struct {
-	ACME                string    `sconf:"optional" sconf-doc:"Name of provider from top-level configuration to use for ACME, e.g. letsencrypt."`
-	KeyCerts            []KeyCert `sconf:"optional" sconf-doc:"Keys and certificates to use for this listener. The files are opened by the privileged root process and passed to the unprivileged mox process, so no special permissions are required on the files. If the private key will not be replaced when refreshing certificates, also consider adding the private key to HostPrivateKeyFiles and configuring DANE TLSA DNS records."`
-	MinVersion          string    `sconf:"optional" sconf-doc:"Minimum TLS version. Default: TLSv1.2."`
-	HostPrivateKeyFiles []string  `sconf:"optional" sconf-doc:"Private keys used for ACME certificates. Specified explicitly so DANE TLSA DNS records can be generated, even before the certificates are requested. DANE is a mechanism to authenticate remote TLS certificates based on a public key or certificate specified in DNS, protected with DNSSEC. DANE is opportunistic and attempted when delivering SMTP with STARTTLS. The private key files must be in PEM format. PKCS8 is recommended, but PKCS1 and EC private keys are recognized as well. Only RSA 2048 bit and ECDSA P-256 keys are currently used. The first of each is used when requesting new certificates through ACME."`
+	ACME                string         `sconf:"optional" sconf-doc:"Name of provider from top-level configuration to use for ACME, e.g. letsencrypt."`
+	KeyCerts            []KeyCert      `sconf:"optional" sconf-doc:"Keys and certificates to use for this listener. The files are opened by the privileged root process and passed to the unprivileged mox process, so no special permissions are required on the files. If the private key will not be replaced when refreshing certificates, also consider adding the private key to HostPrivateKeyFiles and configuring DANE TLSA DNS records."`
+	MinVersion          string         `sconf:"optional" sconf-doc:"Minimum TLS version. Default: TLSv1.2."`
+	CipherSuites        []string       `sconf:"optional" sconf-doc:"Cipher suites to allow for TLS 1.0-1.2, e.g. TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Only cipher suites without known weaknesses can be specified. The cipher suites for TLS 1.3 cannot be configured. Default: all secure cipher suites."`
+	ECHKeyFiles         []string       `sconf:"optional" sconf-doc:"Files with keys for Encrypted Client Hello (ECH), as generated by \"mox ech genkey\": PEM with a PKCS8 X25519 private key and an ECHCONFIG block with the ECHConfigList. With ECH, clients encrypt the TLS client hello, including the requested server name, to the public key. ECH requires TLS 1.3. Clients only use ECH when they find the ECHConfigList in an \"ech\" parameter of a DNS HTTPS record for the host name, protected with DNSSEC, which in practice means only web browsers use it. The first key is sent to clients that use an unknown ECH configuration, so they can retry with the current configuration. Keep old keys configured for a while after publishing new DNS records."`
+	ClientAuth          *TLSClientAuth `sconf:"optional" sconf-doc:"Request TLS client certificates on IMAP and SMTP submission connections, so clients can authenticate with SASL mechanism EXTERNAL instead of a password. Not used for SMTP on port 25 and for HTTPS."`
+	OCSPStapling        bool           `sconf:"optional" sconf-doc:"For static KeyCerts, fetch OCSP responses from the OCSP responder of the CA and include them in TLS handshakes. Responses are refreshed in the background, halfway their validity period. The certificate file must include the issuing intermediate CA certificate. Certificates from ACME providers are not stapled."`
+	HostPrivateKeyFiles []string       `sconf:"optional" sconf-doc:"Private keys used for ACME certificates. Specified explicitly so DANE TLSA DNS records can be generated, even before the certificates are requested. DANE is a mechanism to authenticate remote TLS certificates based on a public key or certificate specified in DNS, protected with DNSSEC. DANE is opportunistic and attempted when delivering SMTP with STARTTLS. The private key files must be in PEM format. PKCS8 is recommended, but PKCS1 and EC private keys are recognized as well. Only RSA 2048 bit and ECDSA P-256 keys are currently used. The first of each is used when requesting new certificates through ACME."`
 
 	Config                   *tls.Config     `sconf:"-" json:"-"` // TLS config for non-ACME-verification connections, i.e. SMTP and IMAP, and not port 443.
 	ACMEConfig               *tls.Config     `sconf:"-" json:"-"` // TLS config that handles ACME verification, for serving on port 443.
+	ClientAuthConfig         *tls.Config     `sconf:"-" json:"-"` // Like Config, but requesting client certificates, for IMAP and SMTP submission. Nil if ClientAuth is not configured.
+	OCSPStapler              *ocsp.Stapler   `sconf:"-" json:"-"` // For KeyCerts with OCSPStapling.
 	HostPrivateRSA2048Keys   []crypto.Signer `sconf:"-" json:"-"` // Private keys for new TLS certificates for listener host name, for new certificates with ACME, and for DANE records.
 	HostPrivateECDSAP256Keys []crypto.Signer `sconf:"-" json:"-"`
 }
 
+type TLSClientAuth struct {
+	CAFiles []string `sconf-doc:"Files with CA certificates in PEM format. Client certificates must be signed by one of these CAs. The e-mail addresses in the subject alternative names of the certificate, or the common name of the subject if it is an e-mail address, are the identities the client can authenticate as. An identity must be an address of an account."`
+
+	CAPool *x509.CertPool `sconf:"-" json:"-"`
+}
+
 type WebHandler struct {
-	LogName               string       `sconf:"optional" sconf-doc:"Name to use in logging and metrics."`
-	Domain                string       `sconf-doc:"Both Domain and PathRegexp must match for this WebHandler to match a request. Exactly one of WebStatic, WebRedirect, WebForward must be set."`
-	PathRegexp            string       `sconf-doc:"Regular expression matched against request path, must always start with ^ to ensure matching from the start of the path. The matching prefix can optionally be stripped by WebForward. The regular expression does not have to end with $."`
-	DontRedirectPlainHTTP bool         `sconf:"optional" sconf-doc:"If set, plain HTTP requests are not automatically permanently redirected (308) to HTTPS. If you don't have a HTTPS webserver configured, set this to true."`
-	Compress              bool         `sconf:"optional" sconf-doc:"Transparently compress responses (currently with gzip) if the client supports it, the status is 200 OK, no Content-Encoding is set on the response yet and the Content-Type of the response hints that the data is compressible (text/..., specific application/... and .../...+json and .../...+xml). For static files only, a cache with compressed files is kept."`
-	WebStatic             *WebStatic   `sconf:"optional" sconf-doc:"Serve static files."`
-	WebRedirect           *WebRedirect `sconf:"optional" sconf-doc:"Redirect requests to configured URL."`
-	WebForward            *WebForward  `sconf:"optional" sconf-doc:"Forward requests to another webserver, i.e. reverse proxy."`
+	LogName               string        `sconf:"optional" sconf-doc:"Name to use in logging and metrics."`
+	Domain                string        `sconf-doc:"Both Domain and PathRegexp must match for this WebHandler to match a request. Exactly one of WebStatic, WebRedirect, WebForward must be set."`
+	PathRegexp            string        `sconf-doc:"Regular expression matched against request path, must always start with ^ to ensure matching from the start of the path. The matching prefix can optionally be stripped by WebForward. The regular expression does not have to end with $."`
+	DontRedirectPlainHTTP bool          `sconf:"optional" sconf-doc:"If set, plain HTTP requests are not automatically permanently redirected (308) to HTTPS. If you don't have a HTTPS webserver configured, set this to true."`
+	Compress              bool          `sconf:"optional" sconf-doc:"Transparently compress responses (currently with gzip) if the client supports it, the status is 200 OK, no Content-Encoding is set on the response yet and the Content-Type of the response hints that the data is compressible (text/..., specific application/... and .../...+json and .../...+xml). For static files only, a cache with compressed files is kept."`
+	Access                *WebAccess    `sconf:"optional" sconf-doc:"Access control. Requests that are not allowed get an error response, other WebHandlers are not tried."`
+	RateLimit             *WebRateLimit `sconf:"optional" sconf-doc:"Limit the request rate per remote IP. Requests above the limit get a 429 too many requests response. Limits are kept in memory, and are reset when the configuration of the WebHandler changes."`
+	Rules                 []WebRule     `sconf:"optional" sconf-doc:"Rules to block requests, e.g. from scanners probing for admin panels, or to limit request body sizes. Evaluated in order after the rate limit and before access control, the first matching rule applies."`
+	WebStatic             *WebStatic    `sconf:"optional" sconf-doc:"Serve static files."`
+	WebRedirect           *WebRedirect  `sconf:"optional" sconf-doc:"Redirect requests to configured URL."`
+	WebForward            *WebForward   `sconf:"optional" sconf-doc:"Forward requests to another webserver, i.e. reverse proxy."`
 
 	Name      string         `sconf:"-"` // Either LogName, or numeric index if LogName was empty. Used instead of LogName in logging/metrics.
 	DNSDomain dns.Domain     `sconf:"-"`
@@ -542,6 +957,9 @@ func (wh WebHandler) Equal(o WebHandler) bool {
 		x.Name = ""
 		x.DNSDomain = dns.Domain{}
 		x.Path = nil
+		x.Access = nil
+		x.RateLimit = nil
+		x.Rules = nil
 		x.WebStatic = nil
 		x.WebRedirect = nil
 		x.WebForward = nil
@@ -549,7 +967,16 @@ func (wh WebHandler) Equal(o WebHandler) bool {
 	}
 	cwh := clean(wh)
 	co := clean(o)
-	if cwh != co {
+	if !reflect.DeepEqual(cwh, co) {
+		return false
+	}
+	if (wh.Access == nil) != (o.Access == nil) || wh.Access != nil && !wh.Access.equal(*o.Access) {
+		return false
+	}
+	if (wh.RateLimit == nil) != (o.RateLimit == nil) || wh.RateLimit != nil && *wh.RateLimit != *o.RateLimit {
+		return false
+	}
+	if !reflect.DeepEqual(cleanRules(wh.Rules), cleanRules(o.Rules)) {
 		return false
 	}
 	if (wh.WebStatic == nil) != (o.WebStatic == nil) || (wh.WebRedirect == nil) != (o.WebRedirect == nil) || (wh.WebForward == nil) != (o.WebForward == nil) {
@@ -567,6 +994,83 @@ func (wh WebHandler) Equal(o WebHandler) bool {
 	return true
 }
 
+// WebAccess is access control for a WebHandler. Requests are first checked
+// against the IP lists, then authentication is required, if configured.
+type WebAccess struct {
+	IPAllow   []string      `sconf:"optional" sconf-doc:"IP addresses or networks in CIDR notation, e.g. 192.0.2.0/24, allowed access. If set, requests from other IPs get a 403 forbidden response."`
+	IPDeny    []string      `sconf:"optional" sconf-doc:"IP addresses or networks in CIDR notation refused access with a 403 forbidden response. Checked before IPAllow."`
+	BasicAuth *WebBasicAuth `sconf:"optional" sconf-doc:"Require HTTP basic authentication. Only over HTTPS, plain HTTP requests get a 403 forbidden response. The Authorization header is not forwarded by WebForward, the authenticated username is passed to the backend in header X-Forwarded-User."`
+	OIDC      *WebOIDCAuth  `sconf:"optional" sconf-doc:"Require login through the OpenID Connect identity provider configured in OIDC in mox.conf. Users without a session are redirected to the identity provider. After login, the identity provider sends them to path /.mox-oidc-callback on the domain of the WebHandler, which must be registered as redirect URI at the identity provider, e.g. https://tools.example.com/.mox-oidc-callback. Sessions are kept in memory for 24 hours, or until mox restarts. Only over HTTPS. The email address of the user is passed to WebForward backends in header X-Forwarded-User. At most one of BasicAuth and OIDC can be set."`
+
+	IPAllowNets []net.IPNet `sconf:"-" json:"-"`
+	IPDenyNets  []net.IPNet `sconf:"-" json:"-"`
+}
+
+func (wa WebAccess) equal(o WebAccess) bool {
+	clean := func(x WebAccess) WebAccess {
+		x.IPAllowNets = nil
+		x.IPDenyNets = nil
+		if x.BasicAuth != nil {
+			ba := *x.BasicAuth
+			ba.HTPasswdPath = ""
+			x.BasicAuth = &ba
+		}
+		return x
+	}
+	return reflect.DeepEqual(clean(wa), clean(o))
+}
+
+// WebBasicAuth is HTTP basic authentication for a WebHandler, with credentials
+// of accounts and/or from an htpasswd file.
+type WebBasicAuth struct {
+	Realm        string   `sconf:"optional" sconf-doc:"Realm, typically shown by browsers in the login prompt. Default: the domain of the WebHandler."`
+	AllAccounts  bool     `sconf:"optional" sconf-doc:"Accept an email address and password of any account."`
+	Accounts     []string `sconf:"optional" sconf-doc:"Accounts whose users can log in with an email address and password of the account."`
+	HTPasswdFile string   `sconf:"optional" sconf-doc:"File with lines with a username and password hash separated by a colon, e.g. generated with \"htpasswd -B\". Only bcrypt hashes are supported. The file is read again when modified. Relative paths are relative to the directory of domains.conf."`
+
+	HTPasswdPath string `sconf:"-" json:"-"`
+}
+
+// WebRateLimit limits the request rate for a WebHandler. Limits apply to the
+// remote IP, and to its networks, like for connections to SMTP and IMAP.
+type WebRateLimit struct {
+	Requests int64         `sconf-doc:"Maximum number of requests in a window from a single IP address, or IPv6 /64 network. Networks are allowed more requests: 3 times as many for an IPv4 /26 or IPv6 /48, and 9 times as many for an IPv4 /21 or IPv6 /32."`
+	Window   time.Duration `sconf:"optional" sconf-doc:"Duration of the window, e.g. 10s or 1h. Default 1m."`
+}
+
+// WebRule blocks requests for a WebHandler, or limits the size of their request
+// bodies. A rule applies to requests that match all its conditions, a rule
+// without conditions applies to all requests.
+type WebRule struct {
+	PathRegexp      string   `sconf:"optional" sconf-doc:"Regular expression matched against the full request path, e.g. ^/wp-(admin|login) or \\.(php|asp)$."`
+	Methods         []string `sconf:"optional" sconf-doc:"HTTP methods, e.g. POST or PUT. Compared case-insensitively."`
+	UserAgentRegexp string   `sconf:"optional" sconf-doc:"Regular expression matched case-insensitively against the User-Agent header, e.g. (sqlmap|nikto|masscan). Use ^$ to match requests without User-Agent."`
+	MaxBodySize     int64    `sconf:"optional" sconf-doc:"If set, matching requests are not blocked, but their request body is limited to this size in bytes. Requests with a larger Content-Length get a 413 content too large response. For request bodies without Content-Length, reading fails after this size. If not set, matching requests get a 403 forbidden response."`
+
+	Path      *regexp.Regexp `sconf:"-" json:"-"`
+	UserAgent *regexp.Regexp `sconf:"-" json:"-"`
+}
+
+func cleanRules(l []WebRule) []WebRule {
+	if l == nil {
+		return nil
+	}
+	nl := make([]WebRule, len(l))
+	for i, rule := range l {
+		rule.Path = nil
+		rule.UserAgent = nil
+		nl[i] = rule
+	}
+	return nl
+}
+
+// WebOIDCAuth is login through the OpenID Connect identity provider for a
+// WebHandler.
+type WebOIDCAuth struct {
+	Emails  []string `sconf:"optional" sconf-doc:"Email addresses of users allowed access. If both Emails and Domains are empty, all users that can log in at the identity provider are allowed access."`
+	Domains []string `sconf:"optional" sconf-doc:"Domains of email addresses of users allowed access."`
+}
+
 type WebStatic struct {
 	StripPrefix      string            `sconf:"optional" sconf-doc:"Path to strip from the request URL before evaluating to a local path. If the requested URL path does not start with this prefix and ContinueNotFound it is considered non-matching and next WebHandlers are tried. If ContinueNotFound is not set, a file not found (404) is returned in that case."`
 	Root             string            `sconf-doc:"Directory to serve files from for this handler. Keep in mind that relative paths are relative to the working directory of mox."`
@@ -598,11 +1102,45 @@ type WebForward struct {
 	URL             string            `sconf-doc:"URL to forward HTTP requests to, e.g. http://127.0.0.1:8123/base. If StripPath is false the full request path is added to the URL. Host headers are sent unmodified. New X-Forwarded-{For,Host,Proto} headers are set. Any query string in the URL is ignored. Requests are made using Go's net/http.DefaultTransport that takes environment variables HTTP_PROXY and HTTPS_PROXY into account. Websocket connections are forwarded and data is copied between client and backend without looking at the framing. The websocket 'version' and 'key'/'accept' headers are verified during the handshake, but other websocket headers, including 'origin', 'protocol' and 'extensions' headers, are not inspected and the backend is responsible for verifying/interpreting them."`
 	ResponseHeaders map[string]string `sconf:"optional" sconf-doc:"Headers to add to the response. Useful for adding security- and cache-related headers."`
 
+	HTTP2                  bool               `sconf:"optional" sconf-doc:"Make requests to the backend with HTTP/2. For http URLs, HTTP/2 without TLS (h2c) with prior knowledge is used, for https URLs HTTP/2 must be negotiated during the TLS handshake. Required for gRPC backends. gRPC clients must connect to mox with HTTP/2 as well, which is offered on HTTPS listeners with ACME. Environment variables HTTP_PROXY and HTTPS_PROXY are not used for HTTP/2 backends. Websocket connections are always made with HTTP/1.1."`
+	DialTimeout            time.Duration      `sconf:"optional" sconf-doc:"Maximum duration for connecting to the backend, including a TLS handshake. Default 30s."`
+	ResponseTimeout        time.Duration      `sconf:"optional" sconf-doc:"Maximum duration to wait for the response headers of the backend after sending the request. The response body is not limited, so responses can be streamed. If the timeout is reached, a 504 gateway timeout response is returned. Default no timeout, except for websocket requests, with a default of 30s."`
+	RequestHeaders         map[string]string  `sconf:"optional" sconf-doc:"Headers to set on requests to the backend, replacing headers with the same name sent by the client."`
+	RequestHeadersRemove   []string           `sconf:"optional" sconf-doc:"Headers to remove from requests to the backend, e.g. cookies or authorization headers the backend should not see. Removed before RequestHeaders are set."`
+	RequestHeaderRewrites  []WebHeaderRewrite `sconf:"optional" sconf-doc:"Rewrites of header values of requests to the backend. Applied after removing headers, before setting headers."`
+	ResponseHeadersRemove  []string           `sconf:"optional" sconf-doc:"Headers to remove from responses from the backend, e.g. headers with software versions. Removed before ResponseHeaders are added."`
+	ResponseHeaderRewrites []WebHeaderRewrite `sconf:"optional" sconf-doc:"Rewrites of header values of responses from the backend, e.g. of Location headers with internal URLs in redirects. Applied after removing headers, before adding headers."`
+
 	TargetURL *url.URL `sconf:"-" json:"-"`
 }
 
+// WebHeaderRewrite replaces values of a header with a regular expression.
+type WebHeaderRewrite struct {
+	Header      string `sconf-doc:"Name of header to rewrite, e.g. Location. All values of the header are rewritten."`
+	ValueRegexp string `sconf-doc:"Regular expression for matching the value. Values that do not match are not changed."`
+	Replace     string `sconf-doc:"Replacement for the matched parts of the value. Implemented with Go's Regexp.ReplaceAllString: $1 is replaced with the text of the first submatch, etc."`
+
+	Value *regexp.Regexp `sconf:"-" json:"-"`
+}
+
 func (wf WebForward) equal(o WebForward) bool {
-	wf.TargetURL = nil
-	o.TargetURL = nil
-	return reflect.DeepEqual(wf, o)
+	clean := func(x WebForward) WebForward {
+		x.TargetURL = nil
+		x.RequestHeaderRewrites = cleanHeaderRewrites(x.RequestHeaderRewrites)
+		x.ResponseHeaderRewrites = cleanHeaderRewrites(x.ResponseHeaderRewrites)
+		return x
+	}
+	return reflect.DeepEqual(clean(wf), clean(o))
+}
+
+func cleanHeaderRewrites(l []WebHeaderRewrite) []WebHeaderRewrite {
+	if l == nil {
+		return nil
+	}
+	nl := make([]WebHeaderRewrite, len(l))
+	for i, hr := range l {
+		hr.Value = nil
+		nl[i] = hr
+	}
+	return nl
 }
@@ -6,9 +6,9 @@ Mox uses two config files:
 1. mox.conf, also called the static configuration file.
 2. domains.conf, also called the dynamic configuration file.
 
-The static configuration file is never reloaded during the lifetime of a
-running mox instance. After changes to mox.conf, mox must be restarted for the
-changes to take effect.
+Changes to the static configuration file are applied on SIGHUP, with "mox
+admin reload" or through the admin web interface. Most settings take effect
+immediately, but some, such as Listeners, ACME and DataDir, require a restart.
 
 The dynamic configuration file is reloaded automatically when it changes.
 If the file contains an error after the change, the reload is aborted and the
@@ -32,6 +32,25 @@ The config files are in "sconf" format. Properties of sconf files:
 
 See https://pkg.go.dev/github.com/mjl-/sconf for details.
 
+# Includes, environment variables and secrets
+
+Before parsing, mox processes the config files, so they can be stored in
+version control without secrets, and shared between environments:
+
+  - A line "!include path", possibly indented, is replaced by the lines of the
+    file at path, with the indenting of the include line added to each line.
+  - "${NAME}" is replaced with the value of environment variable NAME, which
+    must be set. Use "$${" for a literal "${".
+  - A value "!file:path", e.g. "Password: !file:smtp-password", is replaced by
+    the contents of the file at path, without trailing newline. The file must
+    have a single line.
+
+Comment lines are not processed. Relative paths are relative to the directory
+of the file containing the line. Changes to files included from domains.conf are
+noticed when domains.conf itself changes. If domains.conf uses includes,
+environment variables or file references, changes through the admin web
+interface or command-line are refused, the file must be edited instead.
+
 # mox.conf
 
 	# NOTE: This config file is in 'sconf' format. Indent with tabs. Comments must be
@@ -51,11 +70,39 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 	LogLevel:
 
 	# Overrides of log level per package (e.g. queue, smtpclient, smtpserver,
-	# imapserver, spf, dkim, dmarc, dmarcdb, autotls, junk, mtasts, tlsrpt).
-	# (optional)
+	# imapserver, spf, dkim, dmarc, dmarcdb, autotls, junk, mtasts, tlsrpt). Log
+	# levels can be changed while mox is running, in the admin web interface or with
+	# "mox setloglevels". On SIGHUP, mox resets the log levels to LogLevel and
+	# PackageLogLevels from this file. (optional)
 	PackageLogLevels:
 		x:
 
+	# Format of log lines written by "mox serve": logfmt (default) or json. With json,
+	# each line is a JSON object with fields time, level, msg, pkg, followed by the
+	# attributes of the line. (optional)
+	LogFormat:
+
+	# Send log lines to syslog instead of standard error. Lines are sent with facility
+	# daemon and a severity based on the log level. (optional)
+	LogSyslog:
+
+		# Network to send log lines over: udp, tcp or unixgram. If empty, the local syslog
+		# daemon is used through its unix domain socket, e.g. /dev/log. (optional)
+		Network:
+
+		# Address to send log lines to, e.g. host:514 for udp and tcp, or a path for
+		# unixgram. Required if Network is set. (optional)
+		Address:
+
+		# Program name added to each line. Default mox. (optional)
+		Tag:
+
+	# Number of recent log lines to keep in memory, for viewing in the admin web
+	# interface, where they can be filtered by account, message-id and connection id.
+	# Only lines passing the log levels are kept. Default 10000. Use -1 to keep no
+	# lines. (optional)
+	LogRecent: 0
+
 	# User to switch to after binding to all sockets as root. Default: mox. If the
 	# value is not a known user, it is parsed as integer and used as uid and gid.
 	# (optional)
@@ -98,7 +145,10 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 	ACME:
 		x:
 
-			# For letsencrypt, use https://acme-v02.api.letsencrypt.org/directory.
+			# For letsencrypt, use https://acme-v02.api.letsencrypt.org/directory. For
+			# ZeroSSL, which requires external account binding, use
+			# https://acme.zerossl.com/v2/DV90. For Buypass, use
+			# https://api.buypass.com/acme/directory.
 			DirectoryURL:
 
 			# How long before expiration to renew the certificate. Default is 30 days.
@@ -119,7 +169,8 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 
 			# If set, used for suggested CAA DNS records, for restricting TLS certificate
 			# issuance to a Certificate Authority. If empty and DirectyURL is for Let's
-			# Encrypt, this value is set automatically to letsencrypt.org. (optional)
+			# Encrypt, ZeroSSL or Buypass, this value is set automatically to letsencrypt.org,
+			# sectigo.com or buypass.com. (optional)
 			IssuerDomainName:
 
 			# ACME providers can require that a request for a new ACME account reference an
@@ -138,6 +189,69 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 				# mox.conf.
 				KeyFile:
 
+			# Names of other ACME providers to request certificates from, in order, when
+			# requesting a certificate from this provider fails, e.g. during an outage or
+			# after reaching a rate limit. After a failure, fallbacks are used for the host
+			# for 6 hours before trying this provider again. Keep in mind CAA DNS records must
+			# allow the certificate authorities of the fallbacks too. (optional)
+			Fallback:
+				-
+
+			# Hosts to request certificates for from this ACME provider, instead of from the
+			# ACME provider configured for the listener, e.g. to use another certificate
+			# authority for some hosts. A host can only be listed for one ACME provider.
+			# (optional)
+			Hosts:
+				-
+
+			# Request certificates with DNS-01 challenges, by adding TXT records to DNS,
+			# instead of through TLS-ALPN-01 challenges on port 443. Required for wildcard
+			# certificates, and useful for hosts that can't be reached by the ACME provider.
+			# Hosts matching a listed name get the certificate for that name, for all
+			# listeners with this ACME provider. (optional)
+			DNS01:
+
+				# Names to request certificates for, with a certificate per name. A name can be a
+				# wildcard, e.g. *.example.com, which covers a single level of subdomains, but not
+				# example.com itself.
+				Hostnames:
+					-
+
+				# Change DNS records with DNS UPDATE messages, authenticated with a TSIG key.
+				# Either RFC2136 or Command must be set. (optional)
+				RFC2136:
+
+					# Address of DNS server to send updates to, as host:port, e.g. ns1.example.com:53.
+					# Updates are sent over TCP.
+					Server:
+
+					# Zone to update. If empty, the organizational domain of the name is used, e.g.
+					# example.com for _acme-challenge.mail.example.com. (optional)
+					Zone:
+
+					# Name of the TSIG key.
+					TSIGKeyName:
+
+					# TSIG algorithm: hmac-sha256, hmac-sha384 or hmac-sha512. Default hmac-sha256.
+					# (optional)
+					TSIGAlgorithm:
+
+					# File containing the base64-encoded TSIG secret. File is evaluated relative to
+					# the directory of mox.conf.
+					TSIGSecretFile:
+
+				# Shell command to add or remove a TXT record, run with sh -c. Environment
+				# variables ACME_DNS01_ACTION (add or remove), ACME_DNS01_NAME (record name with
+				# trailing dot), ACME_DNS01_ZONE (organizational domain with trailing dot) and
+				# ACME_DNS01_VALUE (TXT record value) are set. The command must exit with status 0
+				# on success. (optional)
+				Command:
+
+				# Time to wait after adding the TXT record before asking the ACME provider to
+				# verify it, for the change to reach all authoritative name servers. Default 1m.
+				# (optional)
+				PropagationDelay: 0s
+
 	# File containing hash of admin password, for authentication in the web admin
 	# pages (if enabled). (optional)
 	AdminPasswordFile:
@@ -200,6 +314,45 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 				# Minimum TLS version. Default: TLSv1.2. (optional)
 				MinVersion:
 
+				# Cipher suites to allow for TLS 1.0-1.2, e.g.
+				# TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256.
+				# Only cipher suites without known weaknesses can be specified. The cipher suites
+				# for TLS 1.3 cannot be configured. Default: all secure cipher suites. (optional)
+				CipherSuites:
+					-
+
+				# Files with keys for Encrypted Client Hello (ECH), as generated by "mox ech
+				# genkey": PEM with a PKCS8 X25519 private key and an ECHCONFIG block with the
+				# ECHConfigList. With ECH, clients encrypt the TLS client hello, including the
+				# requested server name, to the public key. ECH requires TLS 1.3. Clients only use
+				# ECH when they find the ECHConfigList in an "ech" parameter of a DNS HTTPS record
+				# for the host name, protected with DNSSEC, which in practice means only web
+				# browsers use it. The first key is sent to clients that use an unknown ECH
+				# configuration, so they can retry with the current configuration. Keep old keys
+				# configured for a while after publishing new DNS records. (optional)
+				ECHKeyFiles:
+					-
+
+				# Request TLS client certificates on IMAP and SMTP submission connections, so
+				# clients can authenticate with SASL mechanism EXTERNAL instead of a password. Not
+				# used for SMTP on port 25 and for HTTPS. (optional)
+				ClientAuth:
+
+					# Files with CA certificates in PEM format. Client certificates must be signed by
+					# one of these CAs. The e-mail addresses in the subject alternative names of the
+					# certificate, or the common name of the subject if it is an e-mail address, are
+					# the identities the client can authenticate as. An identity must be an address of
+					# an account.
+					CAFiles:
+						-
+
+				# For static KeyCerts, fetch OCSP responses from the OCSP responder of the CA and
+				# include them in TLS handshakes. Responses are refreshed in the background,
+				# halfway their validity period. The certificate file must include the issuing
+				# intermediate CA certificate. Certificates from ACME providers are not stapled.
+				# (optional)
+				OCSPStapling: false
+
 				# Private keys used for ACME certificates. Specified explicitly so DANE TLSA DNS
 				# records can be generated, even before the certificates are requested. DANE is a
 				# mechanism to authenticate remote TLS certificates based on a public key or
@@ -215,6 +368,22 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 			# (optional)
 			SMTPMaxMessageSize: 0
 
+			# Maximum size in bytes for messages added with IMAP APPEND, announced to clients
+			# in the APPENDLIMIT capability. If set, LITERAL- is announced instead of
+			# LITERAL+, limiting non-synchronizing literals to 4096 bytes, so messages that
+			# are too large or would exceed the account quota are rejected before their data
+			# is transferred. Default is 0, for no limit other than the account quota.
+			# (optional)
+			IMAPMaxMessageSize: 0
+
+			# Do not offer or accept authentication that sends the password to the server for
+			# IMAP and SMTP submission on this listener: IMAP LOGIN, and SASL PLAIN and LOGIN.
+			# Clients must authenticate with SCRAM, CRAM-MD5 or, with single sign-on
+			# configured, OAUTHBEARER/XOAUTH2. App passwords, and accounts with external
+			# authentication or two-factor authentication, can only be used with PLAIN and
+			# LOGIN, and cannot authenticate on this listener. (optional)
+			NoPlaintextAuth: false
+
 			# (optional)
 			SMTP:
 				Enabled: false
@@ -246,6 +415,7 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 				# before use, at most once per 4 hours. IPs we can send from are periodically
 				# checked for being in the configured DNSBLs. See MonitorDNSBLs in domains.conf to
 				# only monitor IPs we send from, without using those DNSBLs for incoming messages.
+				# With DNSBLScoring configured, these DNSBLs are not used for incoming messages.
 				# Example DNSBLs: sbl.spamhaus.org, bl.spamcop.net. See
 				# https://www.spamhaus.org/sbl/ and https://www.spamcop.net/ for more information
 				# and terms of use. (optional)
@@ -419,8 +589,93 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 				# limiting and for the "secure" status of cookies. (optional)
 				Forwarded: false
 
-			# Serve prometheus metrics, for monitoring. You should not enable this on a public
-			# IP. (optional)
+			# Like AdminAPIHTTPS, but with plain HTTP, without TLS. (optional)
+			AdminAPIHTTP:
+				Enabled: false
+
+				# Default 80 for HTTP and 443 for HTTPS. (optional)
+				Port: 0
+
+				# Path to serve requests on. (optional)
+				Path:
+
+				# If set, X-Forwarded-* headers are used for the remote IP address for rate
+				# limiting and for the "secure" status of cookies. (optional)
+				Forwarded: false
+
+			# Admin API, a versioned HTTP/JSON-based API for provisioning domains, accounts,
+			# addresses and aliases, authenticated with bearer tokens (see 'mox config
+			# apitoken add'), with HTTPS (requires a TLS config). Default path is /adminapi/.
+			# Preferably only enable on non-public IPs. (optional)
+			AdminAPIHTTPS:
+				Enabled: false
+
+				# Default 80 for HTTP and 443 for HTTPS. (optional)
+				Port: 0
+
+				# Path to serve requests on. (optional)
+				Path:
+
+				# If set, X-Forwarded-* headers are used for the remote IP address for rate
+				# limiting and for the "secure" status of cookies. (optional)
+				Forwarded: false
+
+			# Like DAVHTTPS, but with plain HTTP, without TLS. (optional)
+			DAVHTTP:
+				Enabled: false
+
+				# Default 80 for HTTP and 443 for HTTPS. (optional)
+				Port: 0
+
+				# Path to serve requests on. (optional)
+				Path:
+
+				# If set, X-Forwarded-* headers are used for the remote IP address for rate
+				# limiting and for the "secure" status of cookies. (optional)
+				Forwarded: false
+
+			# CardDAV and CalDAV, for synchronizing the address book and calendar of an
+			# account with phones and desktop clients, with HTTPS (requires a TLS config).
+			# Clients authenticate with an email address and the account password. Default
+			# path is /dav/. Clients discover the path through /.well-known/carddav and
+			# /.well-known/caldav. (optional)
+			DAVHTTPS:
+				Enabled: false
+
+				# Default 80 for HTTP and 443 for HTTPS. (optional)
+				Port: 0
+
+				# Path to serve requests on. (optional)
+				Path:
+
+				# If set, X-Forwarded-* headers are used for the remote IP address for rate
+				# limiting and for the "secure" status of cookies. (optional)
+				Forwarded: false
+
+			# Replication of the data directory to standby instances, see Replication in
+			# mox.conf and "mox replication standby", with HTTPS (requires a TLS config).
+			# Default path is /replication/. Preferably only enable on non-public IPs.
+			# (optional)
+			ReplicationHTTPS:
+				Enabled: false
+
+				# Default 80 for HTTP and 443 for HTTPS. (optional)
+				Port: 0
+
+				# Path to serve requests on. (optional)
+				Path:
+
+				# If set, X-Forwarded-* headers are used for the remote IP address for rate
+				# limiting and for the "secure" status of cookies. (optional)
+				Forwarded: false
+
+			# Serve prometheus metrics at /metrics, for monitoring. Also serves /healthz and
+			# /readyz, for liveness and readiness probes of container orchestration and
+			# external monitoring, responding with status 200 if all checks pass and 503
+			# otherwise. Liveness checks verify the listeners are bound and the queue is
+			# running. Readiness checks also verify accounts can be opened, ACME certificates
+			# do not expire within 7 days, and DNS self-checks pass. You should not enable
+			# this on a public IP. (optional)
 			MetricsHTTP:
 				Enabled: false
 
@@ -465,6 +720,22 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 				# useful when the mta-sts domain is reverse proxied. (optional)
 				NonTLS: false
 
+			# Serve OpenPGP public keys of accounts through the Web Key Directory (WKD), at
+			# openpgpkey.<domain>. Only keys that users have marked for publication in webmail
+			# are served. Requires a TLS config. (optional)
+			WKDHTTPS:
+				Enabled: false
+
+				# TLS port, 443 by default. You should only override this if you cannot listen on
+				# port 443 directly. WKD requests will be made to port 443, so you'll have to add
+				# an external mechanism to get the connection here, e.g. by configuring port
+				# forwarding. (optional)
+				Port: 0
+
+				# If set, plain HTTP instead of HTTPS is spoken on the configured port. Can be
+				# useful when the openpgpkey domain is reverse proxied. (optional)
+				NonTLS: false
+
 			# All configured WebHandlers will serve on an enabled listener. (optional)
 			WebserverHTTP:
 				Enabled: false
@@ -481,10 +752,20 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 				# Port for HTTPS webserver. (optional)
 				Port: 0
 
+			# Also serve HTTP/3 over QUIC on the UDP ports of the HTTPS listeners, e.g. for
+			# webmail, account, admin and webserver, and advertise it to browsers with an
+			# Alt-Svc header in HTTPS responses. Browsers switch to HTTP/3 for later requests,
+			# reducing latency on mobile networks with packet loss or changing connections.
+			# The UDP ports must be reachable, e.g. through firewalls. (optional)
+			HTTP3: false
+
 	# Destination for emails delivered to postmaster addresses: a plain 'postmaster'
 	# without domain, 'postmaster@<hostname>' (also for each listener with SMTP
 	# enabled), and as fallback for each domain without explicitly configured
-	# postmaster destination.
+	# postmaster destination. Also the fallback destination for the role addresses
+	# abuse@ and security@ of each domain. Abuse reports (ARF) received at role
+	# addresses are processed, adding recipients of spam complaints to the suppression
+	# list of the sending account, and listed in the admin web interface.
 	Postmaster:
 		Account:
 
@@ -579,10 +860,11 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 					Username:
 					Password:
 
-					# Allowed authentication mechanisms. Defaults to SCRAM-SHA-256-PLUS,
-					# SCRAM-SHA-256, SCRAM-SHA-1-PLUS, SCRAM-SHA-1, CRAM-MD5. Not included by default:
-					# PLAIN. Specify the strongest mechanism known to be implemented by the server to
-					# prevent mechanism downgrade attacks. (optional)
+					# Allowed authentication mechanisms. Defaults to SCRAM-SHA-512-PLUS,
+					# SCRAM-SHA-512, SCRAM-SHA-256-PLUS, SCRAM-SHA-256, SCRAM-SHA-1-PLUS, SCRAM-SHA-1,
+					# CRAM-MD5. Not included by default: PLAIN. Specify the strongest mechanism known
+					# to be implemented by the server to prevent mechanism downgrade attacks.
+					# (optional)
 					Mechanisms:
 						-
 
@@ -612,10 +894,11 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 					Username:
 					Password:
 
-					# Allowed authentication mechanisms. Defaults to SCRAM-SHA-256-PLUS,
-					# SCRAM-SHA-256, SCRAM-SHA-1-PLUS, SCRAM-SHA-1, CRAM-MD5. Not included by default:
-					# PLAIN. Specify the strongest mechanism known to be implemented by the server to
-					# prevent mechanism downgrade attacks. (optional)
+					# Allowed authentication mechanisms. Defaults to SCRAM-SHA-512-PLUS,
+					# SCRAM-SHA-512, SCRAM-SHA-256-PLUS, SCRAM-SHA-256, SCRAM-SHA-1-PLUS, SCRAM-SHA-1,
+					# CRAM-MD5. Not included by default: PLAIN. Specify the strongest mechanism known
+					# to be implemented by the server to prevent mechanism downgrade attacks.
+					# (optional)
 					Mechanisms:
 						-
 
@@ -645,10 +928,11 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 					Username:
 					Password:
 
-					# Allowed authentication mechanisms. Defaults to SCRAM-SHA-256-PLUS,
-					# SCRAM-SHA-256, SCRAM-SHA-1-PLUS, SCRAM-SHA-1, CRAM-MD5. Not included by default:
-					# PLAIN. Specify the strongest mechanism known to be implemented by the server to
-					# prevent mechanism downgrade attacks. (optional)
+					# Allowed authentication mechanisms. Defaults to SCRAM-SHA-512-PLUS,
+					# SCRAM-SHA-512, SCRAM-SHA-256-PLUS, SCRAM-SHA-256, SCRAM-SHA-1-PLUS, SCRAM-SHA-1,
+					# CRAM-MD5. Not included by default: PLAIN. Specify the strongest mechanism known
+					# to be implemented by the server to prevent mechanism downgrade attacks.
+					# (optional)
 					Mechanisms:
 						-
 
@@ -683,12 +967,12 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 				# remote SMTP servers. (optional)
 				DisableIPv6: false
 
-	# Do not send DMARC reports (aggregate only). By default, aggregate reports on
-	# DMARC evaluations are sent to domains if their DMARC policy requests them.
-	# Reports are sent at whole hours, with a minimum of 1 hour and maximum of 24
-	# hours, rounded up so a whole number of intervals cover 24 hours, aligned at
-	# whole days in UTC. Reports are sent from the postmaster@<mailhostname> address.
-	# (optional)
+	# Do not send DMARC reports, aggregate reports and failure reports configured in
+	# DMARCFailureReports. By default, aggregate reports on DMARC evaluations are sent
+	# to domains if their DMARC policy requests them. Reports are sent at whole hours,
+	# with a minimum of 1 hour and maximum of 24 hours, rounded up so a whole number
+	# of intervals cover 24 hours, aligned at whole days in UTC. Reports are sent from
+	# the postmaster@<mailhostname> address. (optional)
 	NoOutgoingDMARCReports: false
 
 	# Do not send TLS reports. By default, reports about failed SMTP STARTTLS
@@ -714,6 +998,809 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 	# (optional)
 	QuotaMessageSize: 0
 
+	# Store new message files of all accounts compressed (with zstd), in chunks so
+	# parts of messages can still be read efficiently. Messages are decompressed
+	# transparently when read, e.g. through IMAP and webmail. Text-heavy messages
+	# typically compress to a third of their size or less. Existing message files can
+	# be compressed with "mox compressmessages". Can also be enabled per account.
+	# (optional)
+	CompressMessages: false
+
+	# Store identical message files once, e.g. for a message delivered to many
+	# accounts, by hard linking them to a file in the "blobs" directory in the data
+	# directory, named after the SHA-256 hash of the file contents. Blobs no longer
+	# used by any message are removed daily. Encrypted message files are unique per
+	# account and are not deduplicated. Quotas are still calculated with the full size
+	# of each message, so the usage of an account does not change when other accounts
+	# remove their copy of a message. Existing message files can be deduplicated with
+	# "mox dedup", which also shows the disk usage attributed to each account, with
+	# the size of shared files divided between the messages sharing them. Not
+	# supported on Windows. (optional)
+	DeduplicateMessages: false
+
+	# Durability of incoming message deliveries, one of: full (default), relaxed.
+	# Concurrent deliveries to the same account are committed together in a single
+	# database transaction (group commit), e.g. for bursts of mailing list messages.
+	# With full, message files and their directories are synced to disk before the
+	# transaction is committed, so a delivery that is acknowledged to the sender
+	# survives a crash. With relaxed, message files are not explicitly synced,
+	# reducing disk writes further, but a crash or power loss shortly after delivery
+	# can leave messages in the database without (complete) message file, and senders
+	# won't retry. Such messages can be found with "mox fsck". (optional)
+	DeliveryDurability:
+
+	# Single sign-on with an OpenID Connect identity provider. If configured, users
+	# can log in to the webmail, account and admin web interfaces through the identity
+	# provider, and email clients can authenticate to IMAP and SMTP submission with
+	# OAuth 2.0 access tokens from the identity provider, with SASL mechanisms
+	# OAUTHBEARER and XOAUTH2. The email address in the tokens must be a login address
+	# of an account. (optional)
+	OIDC:
+
+		# URL of the issuer, e.g. https://login.example.com/realms/mail. The configuration
+		# of the identity provider is fetched from
+		# <Issuer>/.well-known/openid-configuration.
+		Issuer:
+
+		# Client ID of mox, as registered at the identity provider. Register the URLs of
+		# the web interfaces as redirect URIs, e.g. https://mail.example.com/webmail/,
+		# https://mail.example.com/ and https://mail.example.com/admin/.
+		ClientID:
+
+		# Client secret, for a confidential client. If empty, mox is a public client.
+		# Logins always use PKCE. (optional)
+		ClientSecret:
+
+		# Additional scopes to request for web logins, besides openid, email and profile.
+		# (optional)
+		Scopes:
+			-
+
+		# Audiences accepted in access tokens for IMAP and SMTP submission. If empty,
+		# access tokens are not accepted. Should not include the client ID, which is the
+		# audience of ID tokens. Access tokens must be JWTs of type at+jwt (RFC 9068)
+		# signed by the issuer, or the identity provider must support token introspection,
+		# which requires a client secret. (optional)
+		Audiences:
+			-
+
+		# Claim in ID and access tokens with the email address of the user. Default:
+		# email. If claim email_verified is present and false, the token is not accepted.
+		# (optional)
+		EmailClaim:
+
+		# Email addresses of users allowed to log in to the admin web interface through
+		# the identity provider. (optional)
+		Admins:
+			-
+
+	# Proxy for remote content, such as images, in HTML messages viewed in webmail.
+	# Without the proxy, browsers fetch external resources directly, revealing the IP
+	# address of the reader, and that and when a message is read, to the sender. With
+	# the proxy, resources are fetched by mox, without cookies and referrer, and
+	# cached. Images that look like tracking pixels, with a size of at most 2x2
+	# pixels, are not fetched. Only requests to public IP addresses are made.
+	# (optional)
+	WebmailRemoteContentProxy:
+
+		# Enable the proxy. When viewing HTML messages with external resources, the
+		# resources are fetched through the proxy.
+		Enabled: false
+
+		# Maximum size in bytes of a single resource fetched through the proxy. Default
+		# 5MB. (optional)
+		MaxSize: 0
+
+		# Maximum total size in bytes of resources kept in memory, shared between
+		# accounts. Default 64MB. (optional)
+		CacheSize: 0
+
+		# How long fetched resources are kept in the cache. Default 24h. (optional)
+		CacheTime: 0s
+
+	# Replication of the data directory to standby instances, for failover when this
+	# machine is lost. Standbys run "mox replication standby", connect to a listener
+	# with ReplicationHTTPS enabled, and receive changes as they happen: changed
+	# blocks of databases, and new or rewritten message files. See "mox replication
+	# status" for the state of standbys. The configuration files are not replicated.
+	# (optional)
+	Replication:
+
+		# File with the secret token that standbys authenticate with, e.g. generated with
+		# "openssl rand -base64 24". At least 16 characters. Relative paths are relative
+		# to the directory of mox.conf. The same token must be passed to "mox replication
+		# standby".
+		TokenFile:
+
+	# Periodically read all message files of all accounts in the background, and
+	# compare their contents with the checksum stored when the message was delivered,
+	# to detect corruption of files on disk, such as bit rot on long-lived archives on
+	# consumer disks. Messages delivered before checksums were stored get their
+	# checksum recorded on their first scrub. Corrupt message files are logged,
+	# counted in the metrics, and reported to the postmaster. Corrupt message files
+	# can be restored automatically from copies of the data directory, such as backups
+	# or the data directory of a standby. (optional)
+	Scrub:
+
+		# Time between starts of scrubs of all accounts. A scrub that was interrupted,
+		# e.g. by a restart, continues where it left off. Default 168h, one week.
+		# (optional)
+		Interval: 0s
+
+		# Maximum rate at which message files are read, in bytes per second, to keep the
+		# impact on regular operations low. Default 10MB per second. (optional)
+		BytesPerSecond: 0
+
+		# Directories with copies of the data directory to restore corrupt message files
+		# from, such as backup directories written by "mox backup" or the data directory
+		# of a standby. Message files are looked up at accounts/<account>/msg/ in these
+		# directories. The first copy that matches the checksum replaces the corrupt file.
+		# Relative paths are relative to the directory of mox.conf. (optional)
+		RestoreFrom:
+			-
+
+	# External spam scanners, rspamd and/or SpamAssassin's spamd, to score incoming
+	# messages from senders without reputation, as an additional input besides the
+	# junk filter of the account. The scores of the scanners are scaled to a
+	# probability, so that the score a scanner considers spam (the required score)
+	# equals the junk threshold of the account, and are combined with the probability
+	# of the junk filter. For accounts without junk filter, the message is treated as
+	# junk if a scanner considers it spam. (optional)
+	SpamScan:
+
+		# Score messages with rspamd, through its HTTP protocol. (optional)
+		Rspamd:
+
+			# URL of the rspamd normal worker, e.g. http://localhost:11333. Messages are
+			# submitted to path /checkv2.
+			URL:
+
+			# Password to send with requests, if rspamd requires one. (optional)
+			Password:
+
+		# Score messages with SpamAssassin's spamd, through its SPAMC protocol. (optional)
+		Spamd:
+
+			# Address of spamd, either host:port, e.g. localhost:783, or the absolute path of
+			# a unix domain socket.
+			Address:
+
+			# User to scan messages as, for per-user SpamAssassin preferences. If empty, the
+			# name of the account the message is delivered to is used. (optional)
+			User:
+
+		# Maximum time for scanning a message, per scanner. Default 15s. (optional)
+		Timeout: 0s
+
+		# Weight of the scanners when combining with the probability of the junk filter of
+		# the account, between 0 and 1. If both scanners are configured, their
+		# probabilities are averaged first. Default 0.5. A weight of 1 uses only the
+		# scanners. (optional)
+		Weight: 0.000000
+
+		# If a scanner fails, e.g. due to a timeout, continue delivery without its score.
+		# By default, delivery fails with a temporary error, and the remote server will
+		# retry later. (optional)
+		IgnoreErrors: false
+
+	# Check the contents of incoming messages from senders without reputation against
+	# block lists: the domains of URLs in text and HTML parts against URI block lists,
+	# and SHA-256 hashes of attachments against hash lists, e.g. malware hash feeds. A
+	# message with an attachment in a hash list is rejected, also for senders with a
+	# good reputation. A message with a URL listed in a URI block list is rejected
+	# like a message from an IP in a DNSBL. Delivered messages get an
+	# X-Mox-Content-Blocklists header with the results. (optional)
+	ContentBlocklists:
+
+		# Zones of URI block lists to look up the organizational domain of URLs in, e.g.
+		# multi.surbl.org, multi.uribl.com or dbl.spamhaus.org. Most block lists refuse
+		# queries through public DNS resolvers, so use a local resolver. At most 20
+		# domains are looked up per message. (optional)
+		URIBLs:
+			-
+
+		# Files with hexadecimal SHA-256 hashes of attachments to reject, one per line,
+		# e.g. from malware hash feeds. Empty lines and lines starting with # are ignored,
+		# as is text after the hash on a line. Files are read again when they change, e.g.
+		# after an update by a periodic download. Relative paths are relative to the
+		# directory of mox.conf. (optional)
+		HashFiles:
+			-
+
+	# Limits for concurrent delivery attempts of messages from the outgoing queue.
+	# Messages that are due are delivered round-robin per recipient domain, in order
+	# of the longest waiting message of each domain, so a domain with many deferred
+	# messages does not delay deliveries to other domains. Messages for the same
+	# domain that are delivered in a single attempt, e.g. a message with multiple
+	# recipients at the domain, count as a single delivery. Can be changed while
+	# running. (optional)
+	QueueWorkers:
+
+		# Maximum number of concurrent delivery attempts. Default 10. (optional)
+		Workers: 0
+
+		# Maximum number of concurrent delivery attempts to a single recipient domain.
+		# Some mail servers limit the number of connections per IP, so be careful with
+		# values higher than the default. Default 1. (optional)
+		PerDomain: 0
+
+	# Cache results of DNS lookups in memory for incoming and outgoing message
+	# delivery, e.g. of SPF, DKIM and DMARC records, DNSBLs and MX records. A burst of
+	# messages from the same sender otherwise causes the same lookups for each
+	# message. Concurrent identical lookups are combined into one. Names that don't
+	# exist are cached too (negative caching). Temporary errors are not cached. The
+	# TTLs of DNS records are not available to mox, so results are kept for a fixed
+	# duration, which should be lower than the TTLs of the records looked up. The hit
+	# rate is exported in metric mox_dns_cache_total. (optional)
+	DNSCache:
+
+		# How long successful lookups are kept, including those without records. Default
+		# 1m. (optional)
+		TTL: 0s
+
+		# How long lookups of names that don't exist are kept. Default 30s. (optional)
+		NegativeTTL: 0s
+
+		# Maximum number of lookup results kept. Default 10000. (optional)
+		MaxEntries: 0
+
+	# Score the IP address of incoming messages against multiple DNS block lists and
+	# allow lists, with a weight per list, instead of rejecting a message when its IP
+	# is in any of the DNSBLs of the SMTP listener. The lists are queried
+	# concurrently, and the weights of the lists that contain the IP are summed.
+	# Depending on thresholds, the message is rejected, greylisted or delivered to the
+	# Junk mailbox. Like the DNSBLs of SMTP listeners, lists are only consulted for
+	# messages without enough reputation, with content that looks acceptable. When
+	# configured, the DNSBLs of SMTP listeners are only used for monitoring the IPs we
+	# send from. Lookup results are cached, and the results per list are exported as
+	# metrics, so lists that stopped working or list too much can be spotted.
+	# Delivered messages get an X-Mox-DNSBL-Score header with the score and listings.
+	# (optional)
+	DNSBLScoring:
+
+		# DNS block lists and allow lists to query.
+		Lists:
+			-
+
+				# Zone of the list, e.g. zen.spamhaus.org, bl.spamcop.net or list.dnswl.org.
+				Zone:
+
+				# Added to the score when the IP is listed. Use a negative weight for allow lists
+				# (DNSWLs).
+				Weight: 0.000000
+
+		# Reject messages with a score of at least this value. Default 10. (optional)
+		RejectScore: 0.000000
+
+		# Temporarily reject messages with a score of at least this value, but below
+		# RejectScore, when first seen from the network of the remote IP for the SMTP MAIL
+		# FROM and RCPT TO addresses. A retry after GreylistDelay is accepted. Legitimate
+		# mail servers retry, many spam senders do not. Zero disables greylisting.
+		# (optional)
+		GreylistScore: 0.000000
+
+		# Minimum time before a retry of a greylisted message is accepted. Default 5m.
+		# (optional)
+		GreylistDelay: 0s
+
+		# Deliver messages with a score of at least this value, but below GreylistScore
+		# and RejectScore, to the Junk mailbox. Zero disables tagging. (optional)
+		TagScore: 0.000000
+
+		# How long lookup results are cached. Default 1h. (optional)
+		CacheTTL: 0s
+
+	# Hold incoming messages that would be rejected for one of the configured reasons
+	# in a server-wide quarantine instead. Quarantined messages are accepted from the
+	# remote SMTP server, so the sender does not retry or get a bounce. Admins review
+	# the quarantine in the admin web interface, and release messages, delivering them
+	# to the intended mailbox, or remove them. Accounts can release their own
+	# quarantined messages in the account web interface. Messages in quarantine are
+	# removed automatically after the expiration period. (optional)
+	Quarantine:
+
+		# Reasons for rejecting a message that cause it to be quarantined instead:
+		# junk-content, junk-content-strict, dns-blocklisted, uri-blocklisted,
+		# hash-blocklisted, spamscan, dmarc-policy, iprev, spamtrap.
+		Reasons:
+			-
+
+		# Period after which quarantined messages are removed. Default 720h (30 days).
+		# (optional)
+		Expiration: 0s
+
+		# Deliver a digest message at most once a day to accounts with newly quarantined
+		# messages, listing the messages with a link to the account web interface for
+		# releasing them. (optional)
+		Digest: false
+
+	# Detect anomalies in messages submitted by accounts, through SMTP submission,
+	# webmail and the webapi, that indicate a compromised account, e.g. due to a
+	# stolen password, to prevent damage to the reputation of the IP addresses and
+	# domains of this server. Anomalies are a sudden spike in the number of
+	# recipients, a high rate of bounces (DSN messages received), and spammy content.
+	# Submissions from a network not used before by the account make detection
+	# stricter. When an anomaly is detected, the configured action is taken, and the
+	# postmaster is notified. Incidents are listed in the admin web interface, where
+	# throttles can be cleared. (optional)
+	SubmissionGuard:
+
+		# Action when an anomaly is detected: alert (only notify the postmaster), throttle
+		# (refuse submissions by the account for ThrottleDuration) or freeze (disable
+		# logins to the account, hold its messages in the queue and close its sessions,
+		# until an admin enables logins again). Default throttle. (optional)
+		Action:
+
+		# Duration of throttles. Default 1h. (optional)
+		ThrottleDuration: 0s
+
+		# An account has a recipient spike if the number of recipients in the past hour is
+		# more than this factor times its hourly average over the past week. Default 10.
+		# (optional)
+		RecipientSpikeFactor: 0.000000
+
+		# Minimum number of recipients in the past hour before it can be considered a
+		# spike. Default 50. (optional)
+		RecipientSpikeMin: 0
+
+		# Maximum fraction of recipients in the past 24 hours for which a bounce (DSN
+		# message) or spam complaint (abuse report) was received, between 0 and 1. Default
+		# 0.2. (optional)
+		BounceRate: 0.000000
+
+		# Minimum number of recipients in the past 24 hours before the bounce rate is
+		# considered. Default 20. (optional)
+		BounceMin: 0
+
+		# Also classify the content of submitted messages, with the external spam scanners
+		# if configured in SpamScan, and with the junk filter of the account otherwise. A
+		# message classified as spam is an anomaly. (optional)
+		ContentCheck: false
+
+	# Encrypt message files of accounts at rest, e.g. to protect against disk
+	# snapshots of a rented server. New message files are encrypted with AES-256-GCM,
+	# with a key per account derived from the master key. Reading messages, e.g.
+	# through IMAP and webmail, decrypts transparently. Existing message files are not
+	# encrypted, but can still be read, they are encrypted when compressed with "mox
+	# compressmessages". Headers, message structure and addresses of messages in the
+	# account databases are encrypted with a key per account derived from the master
+	# key too, existing messages are upgraded when the account is opened. Message-IDs
+	# and base subjects, used for threading, and sender addresses, used for
+	# reputation, are stored as keyed hashes. Data needed for lookups, such as sender
+	# domains and IPs for reputation, mailbox names, and recipients of sent messages,
+	# and the contacts, junk filter and queue databases, and message files in the
+	# queue, are not encrypted; use file system encryption if those must be protected
+	# too. Once configured, the key must not be removed, messages in the account
+	# databases cannot be read without it. If the master key is lost, encrypted
+	# messages cannot be read anymore, so keep a copy of the key separate from backups
+	# of the data directory. (optional)
+	MessageEncryption:
+
+		# File with the base64-encoded 32 byte master key, e.g. generated with "openssl
+		# rand -base64 32". Relative paths are relative to the directory of mox.conf. Make
+		# sure only root and the mox user can read the file. (optional)
+		KeyFile:
+
+		# Command to run through "sh -c" to obtain the master key when mox starts, e.g.
+		# for fetching the key from a key management service. The command must print the
+		# base64-encoded 32 byte key on standard output. Exactly one of KeyFile and
+		# KeyCommand must be set. (optional)
+		KeyCommand:
+
+	# Additional labeled series for the Prometheus metrics endpoint, with a label for
+	# destination domains, accounts or configured domains. The number of series grows
+	# with the number of domains and accounts, so these series are opt-in and limited.
+	# Metrics with labels for listeners, protocols and results only are always
+	# exported. (optional)
+	MetricsSeries:
+
+		# Number of destination domains with the most messages in the queue that get their
+		# own series in mox_queue_domain_messages. Messages for other domains are counted
+		# under domain "other". Zero only exports the "other" series. (optional)
+		QueueDomains: 0
+
+		# Export storage used per account in mox_store_account_size_bytes and
+		# mox_store_account_messages, with one series per account each. (optional)
+		AccountStorage: false
+
+		# Periodically check the DNS records of all configured domains, like the check in
+		# the admin web interface, and export the number of errors and warnings per domain
+		# and check in mox_dnscheck_errors and mox_dnscheck_warnings, with about 15 series
+		# per domain each. Results of checks started from the admin web interface are
+		# exported too. Zero disables the checks and series. Minimum 1h. (optional)
+		DNSCheckInterval: 0s
+
+	# Notify about operational problems by email and/or webhook: ACME certificates
+	# that are about to expire because renewal failed, a queue that grows beyond a
+	# threshold, IPs we send from that appear in a DNSBL, a nearly full disk, and many
+	# failed authentication attempts, e.g. due to password brute forcing. Alerts are
+	# also delivered to the postmaster mailbox. While a condition persists, the alert
+	# is repeated periodically. When the condition is resolved and occurs again, a new
+	# alert is sent. Conditions are kept in memory only, so a restart may cause alerts
+	# to be sent again. (optional)
+	Alerting:
+
+		# Email addresses to send alerts to, through the queue, from
+		# postmaster@<hostname>. Preferably addresses at another email provider, so alerts
+		# can be read when this server has problems. (optional)
+		Email:
+			-
+
+		# URL to send alerts to with an HTTP POST request, e.g. an incoming webhook of
+		# Slack or Mattermost, or an ntfy topic. (optional)
+		WebhookURL:
+
+		# Format of webhook requests: json (default), a JSON object with fields Kind, Key,
+		# Subject, Text and Time; slack, a JSON object with field text, for Slack and
+		# compatible incoming webhooks; text, a plain text body with the subject in the
+		# Title header, for ntfy. (optional)
+		WebhookFormat:
+
+		# Additional HTTP headers for webhook requests, e.g. for authentication.
+		# (optional)
+		WebhookHeaders:
+			x:
+
+		# Interval between checks for problems. Default 5m. Minimum 1m. (optional)
+		CheckInterval: 0s
+
+		# Interval for repeating alerts for problems that persist. Default 24h. (optional)
+		RepeatInterval: 0s
+
+		# Alert when an ACME certificate expires within this number of days, indicating
+		# renewal failed. Certificates are renewed 30 days before expiration by default.
+		# Default 14. (optional)
+		CertExpiryDays: 0
+
+		# Alert when the number of messages in the outgoing queue is larger. Default 1000.
+		# (optional)
+		QueueSize: 0
+
+		# Alert when the free space of the file system of the data directory drops below
+		# this percentage. Not supported on all platforms. Default 10. (optional)
+		DiskFreePercent: 0
+
+		# Alert when there were more failed or rate limited authentication attempts since
+		# the previous check, over all protocols. Default 100. (optional)
+		AuthFailures: 0
+
+	# Record OpenTelemetry traces of incoming SMTP transactions, the delivery
+	# pipeline, including junk evaluation, and deliveries from the queue, including
+	# DNS lookups, connections and TLS handshakes, and export them to a collector with
+	# OTLP over HTTP. A message received over SMTP and queued for delivery is traced
+	# end-to-end: delivery attempts from the queue continue the trace of the SMTP
+	# transaction that queued the message. (optional)
+	Tracing:
+
+		# URL of the OpenTelemetry collector to export spans to, with OTLP over HTTP in
+		# its JSON encoding, typically ending in /v1/traces, e.g.
+		# http://localhost:4318/v1/traces.
+		Endpoint:
+
+		# Additional HTTP headers for requests to the endpoint, e.g. for authentication.
+		# (optional)
+		Headers:
+			x:
+
+		# Value for the service.name resource attribute of exported spans. Default mox.
+		# (optional)
+		ServiceName:
+
+		# Fraction of new traces to record, between 0 and 1. Continued traces, e.g. for
+		# deliveries from the queue, follow the decision made when the trace started.
+		# Default 0 records all traces. (optional)
+		SampleRatio: 0.000000
+
+	# Role of this instance in a deployment of multiple mox instances sharing the same
+	# domains and accounts. Frontend nodes are MX hosts that receive incoming messages
+	# over SMTP, and forward them to a storage node that holds the accounts and
+	# message files, and serves IMAP, submission and the web interfaces. Frontend and
+	# storage nodes use identical domains.conf files, e.g. applied with "mox config
+	# apply". Frontend nodes check recipients and reject messages for unknown
+	# addresses, and do not evaluate junk or deliver to accounts themselves. The
+	# storage node evaluates forwarded messages with the IP address and EHLO hostname
+	# of the original sender, passed along with the XCLIENT SMTP extension. If absent,
+	# this instance handles everything itself. (optional)
+	Node:
+
+		# Either frontend or storage. A frontend node only accepts incoming messages over
+		# SMTP and forwards them to the storage node. A storage node accepts forwarded
+		# messages from frontend nodes.
+		Role:
+
+		# For frontend nodes, name of the transport to forward incoming messages to the
+		# storage node with. Must be a transport with method SMTP, Submission or
+		# Submissions, typically SMTP to a port of the storage node with an SMTP listener.
+		# Required for frontend nodes. (optional)
+		StorageTransport:
+
+		# For storage nodes, IP addresses or networks in CIDR notation of frontend nodes.
+		# SMTP connections from these IPs can use the XCLIENT extension to pass the IP
+		# address and EHLO hostname of the original sender, which are then used for
+		# evaluating the message, e.g. for SPF, DNSBLs and reputation. (optional)
+		FrontendIPs:
+			-
+
+	# Accept messages as secondary (backup) MX for domains of which the accounts are
+	# on another mail server, the primary. Messages are accepted when the primary is
+	# unreachable, and the remote sends them to the next MX host in the DNS records of
+	# the domain. The messages are queued, and delivered to the primary when it is
+	# reachable again, through a transport. No accounts are created for these domains,
+	# and they must not be configured in domains.conf. Recipient addresses are checked
+	# against an address list, e.g. synchronized from the primary, so messages for
+	# unknown addresses are rejected during the SMTP transaction instead of causing
+	# bounces later. Add this server as MX host with a lower priority (higher
+	# preference value) than the primary in the DNS records of the domains. Messages
+	# are not checked for junk, the primary should do that. (optional)
+	SecondaryMX:
+
+		# Domains to accept messages for as secondary MX, keyed by domain name.
+		Domains:
+			x:
+
+				# Name of the transport to deliver messages to the primary with. Must be a
+				# transport with method SMTP, Submission or Submissions, typically SMTP with the
+				# host name of the primary MX.
+				Transport:
+
+				# File with the email addresses to accept messages for, one per line. Empty lines
+				# and lines starting with # are ignored. A line with only @ and the domain accepts
+				# all addresses in the domain, e.g. for a catchall address at the primary.
+				# Localparts are compared case-insensitively. The file is read again when it
+				# changes. A mox primary can generate the list with "mox config addresses".
+				# Relative paths are relative to the directory of mox.conf. If absent, messages
+				# for all addresses in the domain are accepted, which is not recommended: messages
+				# for unknown addresses would be rejected by the primary only after they have been
+				# accepted, resulting in bounces to senders that may be forged. (optional)
+				AddressesFile:
+
+				# HTTP or HTTPS URL to periodically fetch the address list from, e.g. from a web
+				# server on the primary. The list is written to AddressesFile, which is required
+				# when AddressesURL is set. If fetching fails, the previous list stays in use.
+				# (optional)
+				AddressesURL:
+
+				# Separators in localparts, after which the remainder is ignored when checking
+				# addresses against the address list, e.g. + to accept user+tag@domain when
+				# user@domain is in the list. Typically the same as configured for the domain at
+				# the primary. (optional)
+				LocalpartCatchallSeparators:
+					-
+
+				# Pass the IP address and EHLO hostname of the original remote SMTP server to the
+				# primary with the XCLIENT SMTP extension, so the primary can evaluate the
+				# message, e.g. for SPF and DNSBLs, as if it was received directly. The primary
+				# must allow XCLIENT from the IPs of this server. For a mox primary, by
+				# configuring Node with role storage and these IPs in FrontendIPs. (optional)
+				XClient: false
+
+		# Interval between fetching the address lists of domains with an AddressesURL.
+		# Default 1h. (optional)
+		SyncInterval: 0s
+
+	# Write an access log for requests handled by WebHandlers from domains.conf, as
+	# JSON lines to a file, separate from the regular mox log. Each line has the time,
+	# handler name, remote IP, authenticated user, method, host, URL, status code,
+	# sizes, duration, user-agent and referrer, and the reason if a request was
+	# blocked by a rate limit or rule. The file is rotated when it reaches its maximum
+	# size. (optional)
+	WebserverAccessLog:
+
+		# Path of the log file. Relative paths are relative to the data directory. Rotated
+		# files get suffixes .1, .2, etc., with .1 the most recent.
+		File:
+
+		# Size in bytes after which the file is rotated. Default 100MB. (optional)
+		MaxSize: 0
+
+		# Number of rotated files to keep, in addition to the current file. Default 5.
+		# (optional)
+		MaxFiles: 0
+
+	# Send DMARC failure reports (forensic reports, ruf= in DMARC records) about
+	# incoming messages that fail DMARC checks, for the listed policy domains only.
+	# Failure reports contain details of individual messages, so they are only sent to
+	# domains that need them, e.g. partner domains debugging their DKIM and SPF setup,
+	# and the original message is redacted: only selected header fields are included,
+	# truncated, and by default no body. The report recipients must be in the ruf=
+	# field of the DMARC record of the domain, and the failure reporting options (fo=)
+	# are honored. Reports are sent from the postmaster@<mailhostname> address,
+	# DKIM-signed like aggregate reports. Reports are not sent when
+	# NoOutgoingDMARCReports is set. (optional)
+	DMARCFailureReports:
+
+		# Policy domains to send failure reports to, keyed by domain name. The domain of a
+		# DMARC record, typically the organizational domain. Messages with a From address
+		# in subdomains match too.
+		Domains:
+			x:
+
+				# Header fields of the original message to include in reports. Other header fields
+				# are left out. Default: From, Sender, Reply-To, To, Cc, Subject, Date,
+				# Message-ID, DKIM-Signature, Authentication-Results, Received-SPF. (optional)
+				Headers:
+					-
+
+				# Maximum size in bytes of each included header field. Longer header fields are
+				# truncated. Default 1000. (optional)
+				MaxHeaderSize: 0
+
+				# Include the first bytes of the message body, up to this size. Default 0, the
+				# body is not included. (optional)
+				BodySize: 0
+
+				# Maximum number of failure reports sent to the domain per hour. Additional
+				# failures are not reported. Default 10. (optional)
+				MaxPerHour: 0
+
+	# How to handle line ending anomalies in message data received by the SMTP server,
+	# for incoming messages and submissions. SMTP requires CRLF line endings. Messages
+	# with a bare CR or LF, or with a dot on a line with other line endings than CRLF,
+	# can be used for SMTP smuggling: a mail server earlier in the path may see
+	# additional messages, with forged From addresses, as part of a single message,
+	# while a mail server that interprets other line endings as end of data sees
+	# separate messages. Each anomaly can be rejected or only logged. Messages with
+	# anomalies are counted in metric mox_smtpserver_line_ending_anomaly_total, and
+	# per remote IP in the admin web interface, so operators can see which senders
+	# would be affected before rejecting. If absent, bare CRs and bad data endings are
+	# rejected, and bare LFs are logged. (optional)
+	SMTPLineEndings:
+
+		# Action for a carriage return (CR) not followed by a newline (LF): reject
+		# (default) or log. With log, the message is accepted with the CR as is.
+		# (optional)
+		BareCR:
+
+		# Action for a newline (LF) not preceded by a carriage return (CR): log (default)
+		# or reject. With log, the message is accepted and a CR is added before the LF.
+		# Some legitimate mail software sends bare LFs. (optional)
+		BareLF:
+
+		# Action for a dot on a line with other line endings than CRLF, e.g. LF.LF or
+		# CRLF.LF, which some mail servers interpret as end of message data: reject
+		# (default) or log. With log, the message is accepted, and the sequence is treated
+		# as message data. Rejecting is recommended, these sequences are hardly used by
+		# legitimate senders. (optional)
+		BadDataEnd:
+
+	# Rules for TLS clients of incoming SMTP, submission and IMAP connections, by
+	# their JA3 or JA4 fingerprint. Fingerprints are calculated from the TLS
+	# ClientHello message, and identify the TLS implementation and its configuration,
+	# not the client host. They are logged for each connection with TLS, and stored
+	# with incoming messages. Rules can block fingerprints of known botnets or abusive
+	# mail software, even when they connect from many different IPs. The first
+	# matching rule applies. Matches are counted in metric
+	# mox_tls_fingerprint_match_total. (optional)
+	TLSFingerprintRules:
+		-
+
+			# JA3 fingerprint to match: an MD5 hash of 32 lower-case hexadecimal characters.
+			# (optional)
+			JA3:
+
+			# JA4 fingerprint to match, e.g. t13d1516h2_8daaf6152771_02713d6af862. Each of the
+			# three parts separated by underscores can be * to match any value, e.g.
+			# t12i0706h2_*_* for a combination of TLS version and number of ciphers and
+			# extensions. Exactly one of JA3 and JA4 must be set. (optional)
+			JA4:
+
+			# Protocols the rule applies to: smtp, submission, submissions, imap, imaps. If
+			# empty, the rule applies to all. (optional)
+			Protocols:
+				-
+
+			# Action for matching connections: reject (default) fails the TLS handshake, log
+			# only logs and counts the match, e.g. to see which connections a rule would block
+			# before rejecting. (optional)
+			Action:
+
+			# Free-form comment, e.g. about the software or botnet the fingerprint belongs to.
+			# Included in log lines about matches. (optional)
+			Comment:
+
+	# Automatically ban remote IPs, or their network, for a while after many failed
+	# authentication attempts or protocol abuses, like fail2ban but built in.
+	# Connections from banned networks to the SMTP submission, IMAP and HTTP listeners
+	# are closed immediately after they are accepted. SMTP for incoming delivery (port
+	# 25) is not affected unless SMTPDelivery is set. Web interface logins through a
+	# reverse proxy are counted for the IP in the X-Forwarded-For header, and refused
+	# while banned. Bans are kept in memory only, so they are lost when mox restarts.
+	# Current bans are listed in the admin web interface, where they can also be
+	# removed. Loopback IPs are never banned. Bans and refused connections are counted
+	# in metrics mox_autoban_total and mox_autoban_refused_total. (optional)
+	AutoBan:
+
+		# Number of failed authentication attempts within Window after which a network is
+		# banned. Counted for IMAP, SMTP submission, web interface logins, the admin and
+		# web APIs, DAV, and web handlers with access control. Default 10. Use -1 to not
+		# ban for failed authentication. (optional)
+		AuthFailures: 0
+
+		# Number of protocol abuses within Window after which a network is banned.
+		# Protocol abuses are connections that don't speak SMTP or IMAP, unknown commands,
+		# and SMTP connections closed after many failed recipients, e.g. when guessing
+		# addresses. Protocol abuse on SMTP for incoming delivery (port 25) is only
+		# counted if SMTPDelivery is set. Default 20. Use -1 to not ban for protocol
+		# abuse. (optional)
+		ProtocolErrors: 0
+
+		# Period in which failures are counted. Default 10m. (optional)
+		Window: 0s
+
+		# How long a ban lasts. Default 1h. (optional)
+		Duration: 0s
+
+		# Prefix length of the network of a remote IPv4 address for which failures are
+		# counted and that is banned. Default 32, only the IP itself. (optional)
+		IPv4Prefix: 0
+
+		# Prefix length of the network of a remote IPv6 address for which failures are
+		# counted and that is banned. Default 64, hosts typically have a /64 to
+		# themselves. (optional)
+		IPv6Prefix: 0
+
+		# IPs or networks in CIDR notation that are never banned, e.g. of an office,
+		# monitoring system or frontend nodes. (optional)
+		Exempt:
+			-
+
+		# Shell command to run with sh -c when a ban starts and when it ends or is
+		# removed, e.g. to add the network to an nftables set or iptables chain so the
+		# firewall drops packets before they reach mox. Environment variables
+		# AUTOBAN_ACTION (ban or unban), AUTOBAN_NETWORK (e.g. 198.51.100.1/32 or
+		# 2001:db8:1:2::/64) and AUTOBAN_DURATION (seconds, for ban) are set. Failures of
+		# the command are logged, the ban in mox remains in place. (optional)
+		Command:
+
+		# Also count protocol abuse on SMTP for incoming delivery (port 25), and close
+		# connections from banned networks to those listeners. Not enabled by default:
+		# legitimate mail servers can trigger protocol errors too, e.g. when delivering to
+		# many stale addresses of a mailing list, and a ban would delay or bounce their
+		# email. (optional)
+		SMTPDelivery: false
+
+	# Requirements for new account passwords, enforced when a password is changed
+	# through the account and admin web interfaces, the admin API and the command
+	# line. Passwords generated by quickstart and localserve are not checked. Accounts
+	# can have their own policy, replacing this one. Without a policy, passwords must
+	# be at least 8 characters. Refused passwords are counted in metric
+	# mox_password_policy_refused_total. (optional)
+	PasswordPolicy:
+
+		# Minimum number of characters. Default and lowest allowed value is 8. (optional)
+		MinLength: 0
+
+		# Minimum estimated strength in bits. The estimate is the number of characters
+		# times the bits per character for the classes of characters used (lower case,
+		# upper case, digits, other ASCII, non-ASCII), with repeated and sequential
+		# characters, like aaa or 123, counting as one. Only a rough estimate, dictionary
+		# words are not recognized, use DenyList and DenyListFile for common passwords.
+		# E.g. 50. Default 0, no minimum. (optional)
+		MinEntropy: 0
+
+		# Passwords that are refused, compared case-insensitively. The account name is
+		# always refused. (optional)
+		DenyList:
+			-
+
+		# File with passwords that are refused, one per line, compared case-insensitively,
+		# e.g. a list of commonly used passwords. Read each time a password is checked.
+		# Relative paths are relative to the directory of mox.conf. (optional)
+		DenyListFile:
+
+		# Refuse passwords found in data breaches, by looking them up with the range API
+		# of Have I Been Pwned. Only the first 5 hexadecimal characters of the SHA-1 hash
+		# of the password are sent (k-anonymity), the password itself is not revealed.
+		# Responses are padded so their size does not reveal which hashes were returned.
+		# If the lookup fails, e.g. due to network errors, the password is accepted and an
+		# error logged. (optional)
+		BreachCheck: false
+
+		# Base URL of the range API, to which the 5 hash characters are appended. E.g. for
+		# a local mirror of the breached password hashes. Default
+		# https://api.pwnedpasswords.com/range/. (optional)
+		BreachCheckURL:
+
 # domains.conf
 
 	# NOTE: This config file is in 'sconf' format. Indent with tabs. Comments must be
@@ -862,6 +1949,15 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 				MX:
 					-
 
+				# If set and Mode is "testing", the policy is automatically changed to mode
+				# "enforce" with a new policy ID when TLS reports (TLSRPT) for the domain have not
+				# shown failures for this period, e.g. 336h for two weeks. At least one TLS report
+				# without failures must have been received. The period starts when the policy with
+				# its current ID is first seen in mode testing, and again after each TLS report
+				# with failures. After promotion, the DNS record must be updated with the new
+				# policy ID. (optional)
+				EnforceAfter: 0s
+
 			# With TLSRPT a domain specifies in DNS where reports about encountered SMTP TLS
 			# behaviour should be sent. Useful for monitoring. Incoming TLS reports are
 			# automatically parsed, validated, added to metrics and stored in the reporting
@@ -919,11 +2015,14 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 			Aliases:
 				x:
 
-					# Expanded addresses to deliver to. These must currently be of addresses of local
-					# accounts. To prevent duplicate messages, a member address that is also an
-					# explicit recipient in the SMTP transaction will only have the message delivered
-					# once. If the address in the message From header is a member, that member also
-					# won't receive the message.
+					# Expanded addresses to deliver to. Addresses in configured domains must be
+					# addresses of local accounts. Addresses in other domains are external members,
+					# messages for them are added to the queue for delivery, with the owner address as
+					# SMTP MAIL FROM. At least one member must be a local address, the junk filtering
+					# of its account is used to decide whether to accept a message. To prevent
+					# duplicate messages, a member address that is also an explicit recipient in the
+					# SMTP transaction will only have the message delivered once. If the address in
+					# the message From header is a member, that member also won't receive the message.
 					Addresses:
 						-
 
@@ -938,6 +2037,186 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 					# message From header. (optional)
 					AllowMsgFrom: false
 
+					# Account that created this alias through the account web interface, and that can
+					# remove it. Aliases created by an account count towards its MaxAliases limit.
+					# (optional)
+					Owner:
+
+					# Local address responsible for the alias. Used as SMTP MAIL FROM for messages
+					# forwarded to external members, so delivery failure notifications (DSNs) are
+					# delivered to its account. Also receives requests to approve held messages for
+					# moderated aliases. If empty, the postmaster address of the domain of the alias
+					# is used, with notifications delivered to the postmaster account. (optional)
+					OwnerAddress:
+
+					# If true and PostPublic is false, messages from non-members are not rejected but
+					# held for approval. The owner address is sent a message with a secret link to a
+					# page where the message can be approved or rejected. Held messages that are not
+					# approved within a week are removed. (optional)
+					Moderated: false
+
+			# Require two-factor authentication with TOTP codes for web logins of accounts
+			# that have this domain as their default domain. See RequireTOTP for accounts.
+			# (optional)
+			RequireTOTP: false
+
+			# Login addresses of accounts that can manage this domain in the admin web
+			# interface, logging in with the email address and password of their account.
+			# Domain admins can manage accounts, addresses and aliases, DKIM keys and
+			# reporting addresses of their domains, and view DMARC and TLS reports for their
+			# domains. They cannot see other domains or server-wide settings. Addresses do not
+			# have to be in this domain, e.g. for a hosting customer managing multiple
+			# domains. (optional)
+			Admins:
+				-
+
+			# Senders, email addresses or domains as "@domain", whose messages to addresses in
+			# this domain skip junk filtering and DNS block list checks. Only applied to
+			# messages with a DMARC pass for the message From address, or an SPF pass for the
+			# SMTP MAIL FROM address. Parent domains of the sender match too. Entries in the
+			# sender lists of an account take precedence. (optional)
+			SenderAllow:
+				-
+
+			# Senders, email addresses or domains as "@domain", whose messages to addresses in
+			# this domain are rejected. Matched against the message From address and the SMTP
+			# MAIL FROM address. (optional)
+			SenderReject:
+				-
+
+			# Senders, email addresses or domains as "@domain", whose messages to addresses in
+			# this domain are delivered to the Junk mailbox, marked as junk. (optional)
+			SenderJunk:
+				-
+
+			# Localparts of spamtrap addresses in this domain, addresses not used for real
+			# mail, e.g. published only where harvesters find them. Messages to spamtraps are
+			# accepted but never delivered. Their content trains the shared junk filter as
+			# junk, and the remote network and validated sender domain get a bad reputation,
+			# causing their messages to other addresses to be rejected for 30 days. Hits are
+			# listed in the admin web interface. Must not be an existing address or alias.
+			# (optional)
+			Spamtraps:
+				-
+
+			# Verify passwords for login addresses in this domain with an external
+			# authentication backend, LDAP or PAM, instead of the password stored in the
+			# account. App passwords, passkeys and two-factor authentication keep working as
+			# with local passwords. Authentication mechanisms that need a locally stored
+			# password, such as SCRAM and CRAM-MD5, cannot be used, email clients must use a
+			# mechanism like PLAIN that sends the password. (optional)
+			Auth:
+
+				# Verify passwords by binding to an LDAP server, e.g. OpenLDAP or Active
+				# Directory. (optional)
+				LDAP:
+
+					# URL of the LDAP server, with scheme ldap or ldaps, e.g.
+					# ldaps://ldap.example.com. Default ports are 389 for ldap and 636 for ldaps. The
+					# TLS certificate is verified.
+					URL:
+
+					# For ldap URLs, switch the connection to TLS with the StartTLS operation before
+					# sending credentials. Required for ldap URLs, unless the LDAP server is on a
+					# loopback address or localhost. (optional)
+					StartTLS: false
+
+					# If set, bind directly with the DN for the user, with %s replaced by the
+					# username, e.g. uid=%s,ou=people,dc=example,dc=com. For Active Directory, a user
+					# principal name can be used instead of a DN, e.g. %s@example.com. If not set, the
+					# DN of the user is looked up with a search for Filter under BaseDN. (optional)
+					UserDNTemplate:
+
+					# DN to bind with before searching for the user. If empty, the search is done
+					# anonymously. (optional)
+					BindDN:
+
+					# Password for BindDN. (optional)
+					BindPassword:
+
+					# DN of the subtree to search for users, e.g. ou=people,dc=example,dc=com.
+					# (optional)
+					BaseDN:
+
+					# Search filter for finding the user, with %s replaced by the escaped username.
+					# Exactly one entry must match. E.g. (&(objectClass=inetOrgPerson)(uid=%s)), or
+					# (&(objectClass=user)(sAMAccountName=%s)) for Active Directory. (optional)
+					Filter:
+
+				# Verify passwords with PAM, the pluggable authentication modules of Unix systems.
+				# Only available when mox is built with cgo and build tag "pam". (optional)
+				PAM:
+
+					# PAM service name, selecting the configuration file in /etc/pam.d/. Default mox.
+					# Note that mox runs as an unprivileged user, PAM modules that require root
+					# privileges will not work. (optional)
+					Service:
+
+				# Pass the full email address as username to the backend. By default, only the
+				# localpart of the login address is used. (optional)
+				UsernameEmail: false
+
+				# On the first successful login with an address in this domain that is not yet
+				# configured, create an account with that address. The account is named after the
+				# localpart, or after the full address if an account with the localpart as name
+				# already exists. (optional)
+				AutoProvision: false
+
+			# Footer, e.g. a legal disclaimer, added to the text of messages submitted by
+			# accounts with a message From address in this domain. A footer configured for the
+			# account takes precedence. (optional)
+			Footer:
+
+				# Lines of the plain text footer, added after an empty line at the end of
+				# text/plain parts. If absent, text/plain parts are left unchanged. (optional)
+				Text:
+					-
+
+				# Lines of the HTML footer, inserted before the closing body tag of text/html
+				# parts, or added at the end if absent. If absent, the plain text footer is
+				# HTML-escaped and used instead. (optional)
+				HTML:
+					-
+
+				# Don't add the footer to replies, i.e. messages with an In-Reply-To or References
+				# header. Messages earlier in a thread typically already have the footer.
+				# (optional)
+				SkipReplies: false
+
+			# Rules for rewriting addresses in this domain, e.g. to masquerade internal
+			# addresses, or to map addresses onto another domain during a migration. Evaluated
+			# together with the global AddressRewrites, see there for details. (optional)
+			AddressRewrites:
+				-
+
+					# Regular expression matched against the full email address, e.g.
+					# ^(.+)@internal\.example$. The domain is in lower case, with unicode characters
+					# for internationalized domains. Matching is case-insensitive. Addresses that do
+					# not match are not changed.
+					AddressRegexp:
+
+					# Replacement for the matched address, e.g. $1@example.org. Implemented with Go's
+					# Regexp.ReplaceAllString: $1 is replaced with the text of the first submatch,
+					# etc. The result must be a valid email address.
+					Replace:
+
+					# Rules with a higher priority are evaluated first. For the same priority, rules
+					# of the domain of the address are evaluated before global rules, then in
+					# configured order. (optional)
+					Priority: 0
+
+					# Rewrite sender addresses of messages submitted by accounts: the SMTP MAIL FROM
+					# address and the address in the message From header. The account must be allowed
+					# to send with the original address. The rewritten address must be in a configured
+					# domain, messages are DKIM-signed for that domain. (optional)
+					Sender: false
+
+					# Rewrite recipient addresses, i.e. SMTP RCPT TO, of incoming messages and of
+					# messages submitted by accounts. Incoming messages are delivered to the rewritten
+					# address, submitted messages are sent to it. Message headers are not changed.
+					# (optional)
+					Recipient: false
+
 	# Accounts represent mox users, each with a password and email address(es) to
 	# which email can be delivered (possibly at different domains). Each account has
 	# its own on-disk directory holding its messages and index database. An account
@@ -1105,6 +2384,10 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 			# Useful to prevent a single account from filling storage. (optional)
 			QuotaMessageSize: 0
 
+			# Store new message files of this account compressed, also see CompressMessages in
+			# mox.conf for enabling compression for all accounts. (optional)
+			CompressMessages: false
+
 			# Mail that looks like spam will be rejected, but a copy can be stored temporarily
 			# in a mailbox, e.g. Rejects. If mail isn't coming in when you expect, you can
 			# look there. The mail still isn't accepted, so the remote mail server may retry
@@ -1153,6 +2436,29 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 				# spammers to identify words that strongly indicate non-spaminess and use it to
 				# bypass the filter. E.g. 0.95.
 				Threshold: 0.000000
+
+				# If larger than 0, incoming messages are also classified with the shared junk
+				# filter, which is trained with messages from all accounts that have
+				# ContributeShared set. The spam probabilities of the junk filter of this account
+				# and the shared junk filter are combined, with this weight for the shared junk
+				# filter, between 0 and 1. If the junk filter of this account does not recognize
+				# any significant words, e.g. for a new account without history, the probability
+				# of the shared junk filter is used as is. E.g. 0.3. (optional)
+				SharedWeight: 0.000000
+
+				# Also train the shared junk filter with messages of this account that are marked
+				# as junk or nonjunk. Only enable for accounts whose junk/nonjunk classifications
+				# can be trusted. (optional)
+				ContributeShared: false
+
+				# By default, the junk filter is retrained immediately when the junk/nonjunk flags
+				# of messages change, e.g. when an email client moves messages to/from the Junk
+				# mailbox (with AutomaticJunkFlags), or sets/clears the $Junk or $NotJunk flags.
+				# If set, retraining is delayed: a daily background job retrains the backlog of
+				# messages with changed flags. Can be useful for accounts where flags of many
+				# messages are changed at a time. The backlog can also be retrained with "mox
+				# retrain -backlog". (optional)
+				DelayFlagTraining: false
 				Params:
 
 					# Track ham/spam ranking for single words. (optional)
@@ -1189,11 +2495,30 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 			# this mail server in case of account compromise. Default 200. (optional)
 			MaxFirstTimeRecipientsPerDay: 0
 
+			# Maximum number of aliases the account can create itself through the account web
+			# interface, in domains of its addresses and with its own addresses as members.
+			# Default 0, account can not create aliases. (optional)
+			MaxAliases: 0
+
 			# Do not apply a delay to SMTP connections before accepting an incoming message
 			# from a first-time sender. Can be useful for accounts that sends automated
 			# responses and want instant replies. (optional)
 			NoFirstTimeSenderDelay: false
 
+			# Require two-factor authentication with TOTP codes (from an authenticator app)
+			# for logging in to the webmail and account web interfaces. Until two-factor
+			# authentication is set up, only logins to the account web interface are allowed,
+			# for setting it up. With two-factor authentication enabled, the account password
+			# can no longer be used for IMAP and SMTP submission, app passwords must be used
+			# instead. (optional)
+			RequireTOTP: false
+
+			# If non-empty, login attempts on all protocols (e.g. SMTP/IMAP, web interfaces)
+			# are rejected with this error message, and existing web sessions can no longer be
+			# used. Set while deletion of the account is pending. Incoming deliveries for
+			# addresses of this account are still accepted. (optional)
+			LoginDisabled:
+
 			# Routes for delivering outgoing messages through the queue. Each delivery attempt
 			# evaluates these account routes, domain routes and finally global routes. The
 			# transport of the first matching route is used in the delivery attempt. If no
@@ -1218,6 +2543,65 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 					MinimumAttempts: 0
 					Transport:
 
+			# Footer, e.g. a legal disclaimer, added to the text of messages submitted by this
+			# account. Takes precedence over a footer configured for the domain of the message
+			# From address. (optional)
+			Footer:
+
+				# Lines of the plain text footer, added after an empty line at the end of
+				# text/plain parts. If absent, text/plain parts are left unchanged. (optional)
+				Text:
+					-
+
+				# Lines of the HTML footer, inserted before the closing body tag of text/html
+				# parts, or added at the end if absent. If absent, the plain text footer is
+				# HTML-escaped and used instead. (optional)
+				HTML:
+					-
+
+				# Don't add the footer to replies, i.e. messages with an In-Reply-To or References
+				# header. Messages earlier in a thread typically already have the footer.
+				# (optional)
+				SkipReplies: false
+
+			# Requirements for new passwords of this account, replacing the PasswordPolicy
+			# from mox.conf. (optional)
+			PasswordPolicy:
+
+				# Minimum number of characters. Default and lowest allowed value is 8. (optional)
+				MinLength: 0
+
+				# Minimum estimated strength in bits. The estimate is the number of characters
+				# times the bits per character for the classes of characters used (lower case,
+				# upper case, digits, other ASCII, non-ASCII), with repeated and sequential
+				# characters, like aaa or 123, counting as one. Only a rough estimate, dictionary
+				# words are not recognized, use DenyList and DenyListFile for common passwords.
+				# E.g. 50. Default 0, no minimum. (optional)
+				MinEntropy: 0
+
+				# Passwords that are refused, compared case-insensitively. The account name is
+				# always refused. (optional)
+				DenyList:
+					-
+
+				# File with passwords that are refused, one per line, compared case-insensitively,
+				# e.g. a list of commonly used passwords. Read each time a password is checked.
+				# Relative paths are relative to the directory of mox.conf. (optional)
+				DenyListFile:
+
+				# Refuse passwords found in data breaches, by looking them up with the range API
+				# of Have I Been Pwned. Only the first 5 hexadecimal characters of the SHA-1 hash
+				# of the password are sent (k-anonymity), the password itself is not revealed.
+				# Responses are padded so their size does not reveal which hashes were returned.
+				# If the lookup fails, e.g. due to network errors, the password is accepted and an
+				# error logged. (optional)
+				BreachCheck: false
+
+				# Base URL of the range API, to which the 5 hash characters are appended. E.g. for
+				# a local mirror of the breached password hashes. Default
+				# https://api.pwnedpasswords.com/range/. (optional)
+				BreachCheckURL:
+
 	# Redirect all requests from domain (key) to domain (value). Always redirects to
 	# HTTPS. For plain HTTP redirects, use a WebHandler with a WebRedirect. (optional)
 	WebDomainRedirects:
@@ -1256,6 +2640,103 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 			# only, a cache with compressed files is kept. (optional)
 			Compress: false
 
+			# Access control. Requests that are not allowed get an error response, other
+			# WebHandlers are not tried. (optional)
+			Access:
+
+				# IP addresses or networks in CIDR notation, e.g. 192.0.2.0/24, allowed access. If
+				# set, requests from other IPs get a 403 forbidden response. (optional)
+				IPAllow:
+					-
+
+				# IP addresses or networks in CIDR notation refused access with a 403 forbidden
+				# response. Checked before IPAllow. (optional)
+				IPDeny:
+					-
+
+				# Require HTTP basic authentication. Only over HTTPS, plain HTTP requests get a
+				# 403 forbidden response. The Authorization header is not forwarded by WebForward,
+				# the authenticated username is passed to the backend in header X-Forwarded-User.
+				# (optional)
+				BasicAuth:
+
+					# Realm, typically shown by browsers in the login prompt. Default: the domain of
+					# the WebHandler. (optional)
+					Realm:
+
+					# Accept an email address and password of any account. (optional)
+					AllAccounts: false
+
+					# Accounts whose users can log in with an email address and password of the
+					# account. (optional)
+					Accounts:
+						-
+
+					# File with lines with a username and password hash separated by a colon, e.g.
+					# generated with "htpasswd -B". Only bcrypt hashes are supported. The file is read
+					# again when modified. Relative paths are relative to the directory of
+					# domains.conf. (optional)
+					HTPasswdFile:
+
+				# Require login through the OpenID Connect identity provider configured in OIDC in
+				# mox.conf. Users without a session are redirected to the identity provider. After
+				# login, the identity provider sends them to path /.mox-oidc-callback on the
+				# domain of the WebHandler, which must be registered as redirect URI at the
+				# identity provider, e.g. https://tools.example.com/.mox-oidc-callback. Sessions
+				# are kept in memory for 24 hours, or until mox restarts. Only over HTTPS. The
+				# email address of the user is passed to WebForward backends in header
+				# X-Forwarded-User. At most one of BasicAuth and OIDC can be set. (optional)
+				OIDC:
+
+					# Email addresses of users allowed access. If both Emails and Domains are empty,
+					# all users that can log in at the identity provider are allowed access.
+					# (optional)
+					Emails:
+						-
+
+					# Domains of email addresses of users allowed access. (optional)
+					Domains:
+						-
+
+			# Limit the request rate per remote IP. Requests above the limit get a 429 too
+			# many requests response. Limits are kept in memory, and are reset when the
+			# configuration of the WebHandler changes. (optional)
+			RateLimit:
+
+				# Maximum number of requests in a window from a single IP address, or IPv6 /64
+				# network. Networks are allowed more requests: 3 times as many for an IPv4 /26 or
+				# IPv6 /48, and 9 times as many for an IPv4 /21 or IPv6 /32.
+				Requests: 0
+
+				# Duration of the window, e.g. 10s or 1h. Default 1m. (optional)
+				Window: 0s
+
+			# Rules to block requests, e.g. from scanners probing for admin panels, or to
+			# limit request body sizes. Evaluated in order after the rate limit and before
+			# access control, the first matching rule applies. (optional)
+			Rules:
+				-
+
+					# Regular expression matched against the full request path, e.g.
+					# ^/wp-(admin|login) or \.(php|asp)$. (optional)
+					PathRegexp:
+
+					# HTTP methods, e.g. POST or PUT. Compared case-insensitively. (optional)
+					Methods:
+						-
+
+					# Regular expression matched case-insensitively against the User-Agent header,
+					# e.g. (sqlmap|nikto|masscan). Use ^$ to match requests without User-Agent.
+					# (optional)
+					UserAgentRegexp:
+
+					# If set, matching requests are not blocked, but their request body is limited to
+					# this size in bytes. Requests with a larger Content-Length get a 413 content too
+					# large response. For request bodies without Content-Length, reading fails after
+					# this size. If not set, matching requests get a 403 forbidden response.
+					# (optional)
+					MaxBodySize: 0
+
 			# Serve static files. (optional)
 			WebStatic:
 
@@ -1343,6 +2824,77 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 				ResponseHeaders:
 					x:
 
+				# Make requests to the backend with HTTP/2. For http URLs, HTTP/2 without TLS
+				# (h2c) with prior knowledge is used, for https URLs HTTP/2 must be negotiated
+				# during the TLS handshake. Required for gRPC backends. gRPC clients must connect
+				# to mox with HTTP/2 as well, which is offered on HTTPS listeners with ACME.
+				# Environment variables HTTP_PROXY and HTTPS_PROXY are not used for HTTP/2
+				# backends. Websocket connections are always made with HTTP/1.1. (optional)
+				HTTP2: false
+
+				# Maximum duration for connecting to the backend, including a TLS handshake.
+				# Default 30s. (optional)
+				DialTimeout: 0s
+
+				# Maximum duration to wait for the response headers of the backend after sending
+				# the request. The response body is not limited, so responses can be streamed. If
+				# the timeout is reached, a 504 gateway timeout response is returned. Default no
+				# timeout, except for websocket requests, with a default of 30s. (optional)
+				ResponseTimeout: 0s
+
+				# Headers to set on requests to the backend, replacing headers with the same name
+				# sent by the client. (optional)
+				RequestHeaders:
+					x:
+
+				# Headers to remove from requests to the backend, e.g. cookies or authorization
+				# headers the backend should not see. Removed before RequestHeaders are set.
+				# (optional)
+				RequestHeadersRemove:
+					-
+
+				# Rewrites of header values of requests to the backend. Applied after removing
+				# headers, before setting headers. (optional)
+				RequestHeaderRewrites:
+					-
+
+						# Name of header to rewrite, e.g. Location. All values of the header are
+						# rewritten.
+						Header:
+
+						# Regular expression for matching the value. Values that do not match are not
+						# changed.
+						ValueRegexp:
+
+						# Replacement for the matched parts of the value. Implemented with Go's
+						# Regexp.ReplaceAllString: $1 is replaced with the text of the first submatch,
+						# etc.
+						Replace:
+
+				# Headers to remove from responses from the backend, e.g. headers with software
+				# versions. Removed before ResponseHeaders are added. (optional)
+				ResponseHeadersRemove:
+					-
+
+				# Rewrites of header values of responses from the backend, e.g. of Location
+				# headers with internal URLs in redirects. Applied after removing headers, before
+				# adding headers. (optional)
+				ResponseHeaderRewrites:
+					-
+
+						# Name of header to rewrite, e.g. Location. All values of the header are
+						# rewritten.
+						Header:
+
+						# Regular expression for matching the value. Values that do not match are not
+						# changed.
+						ValueRegexp:
+
+						# Replacement for the matched parts of the value. Implemented with Go's
+						# Regexp.ReplaceAllString: $1 is replaced with the text of the first submatch,
+						# etc.
+						Replace:
+
 	# Routes for delivering outgoing messages through the queue. Each delivery attempt
 	# evaluates account routes, domain routes and finally these global routes. The
 	# transport of the first matching route is used in the delivery attempt. If no
@@ -1367,6 +2919,44 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 			MinimumAttempts: 0
 			Transport:
 
+	# Rules for rewriting email addresses, e.g. to map a legacy domain onto a new
+	# domain during a migration, or to masquerade addresses of an internal domain.
+	# Applied to recipient addresses of incoming messages and of messages submitted by
+	# accounts, and to sender addresses of submitted messages. For an address, the
+	# rules of its domain and these global rules are evaluated by descending priority,
+	# only the first matching rule is applied. Rewritten addresses are not rewritten
+	# again. (optional)
+	AddressRewrites:
+		-
+
+			# Regular expression matched against the full email address, e.g.
+			# ^(.+)@internal\.example$. The domain is in lower case, with unicode characters
+			# for internationalized domains. Matching is case-insensitive. Addresses that do
+			# not match are not changed.
+			AddressRegexp:
+
+			# Replacement for the matched address, e.g. $1@example.org. Implemented with Go's
+			# Regexp.ReplaceAllString: $1 is replaced with the text of the first submatch,
+			# etc. The result must be a valid email address.
+			Replace:
+
+			# Rules with a higher priority are evaluated first. For the same priority, rules
+			# of the domain of the address are evaluated before global rules, then in
+			# configured order. (optional)
+			Priority: 0
+
+			# Rewrite sender addresses of messages submitted by accounts: the SMTP MAIL FROM
+			# address and the address in the message From header. The account must be allowed
+			# to send with the original address. The rewritten address must be in a configured
+			# domain, messages are DKIM-signed for that domain. (optional)
+			Sender: false
+
+			# Rewrite recipient addresses, i.e. SMTP RCPT TO, of incoming messages and of
+			# messages submitted by accounts. Incoming messages are delivered to the rewritten
+			# address, submitted messages are sent to it. Message headers are not changed.
+			# (optional)
+			Recipient: false
+
 	# DNS blocklists to periodically check with if IPs we send from are present,
 	# without using them for checking incoming deliveries.. Also see DNSBLs in SMTP
 	# listeners in mox.conf, which specifies DNSBLs to use both for incoming
@@ -1375,6 +2965,11 @@ See https://pkg.go.dev/github.com/mjl-/sconf for details.
 	MonitorDNSBLs:
 		-
 
+	# Schema version of this file. Files with an older or missing version are migrated
+	# automatically when mox starts, e.g. when fields were renamed. See "mox config
+	# migrate". (optional)
+	Version: 0
+
 # Examples
 
 Mox includes configuration files to illustrate common setups. You can see these
@@ -1489,10 +3084,11 @@ config example <name>". Below are all examples included in mox.
 					Username: user@example.com
 					Password: test1234
 					Mechanisms:
-						# Allowed authentication mechanisms. Defaults to SCRAM-SHA-256-PLUS,
-						# SCRAM-SHA-256, SCRAM-SHA-1-PLUS, SCRAM-SHA-1, CRAM-MD5. Not included by default:
-						# PLAIN. Specify the strongest mechanism known to be implemented by the server to
-						# prevent mechanism downgrade attacks. (optional)
+						# Allowed authentication mechanisms. Defaults to SCRAM-SHA-512-PLUS,
+						# SCRAM-SHA-512, SCRAM-SHA-256-PLUS, SCRAM-SHA-256, SCRAM-SHA-1-PLUS, SCRAM-SHA-1,
+						# CRAM-MD5. Not included by default: PLAIN. Specify the strongest mechanism known
+						# to be implemented by the server to prevent mechanism downgrade attacks.
+						# (optional)
 
 						- SCRAM-SHA-256-PLUS
 
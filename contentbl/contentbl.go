@@ -0,0 +1,312 @@
+// Package contentbl checks the contents of incoming messages against block
+// lists: domains of URLs in text and HTML parts against URI block lists (e.g.
+// SURBL, URIBL, Spamhaus DBL), and SHA-256 hashes of attachments against hash
+// lists, e.g. from malware hash feeds.
+//
+// URI block lists are queried with DNS "A" lookups of the organizational domain
+// of a URL, prepended to the zone of the block list, e.g.
+// "example.com.multi.surbl.org". If an address is returned, the domain is
+// listed. Addresses 127.0.0.1 and 127.255.255.0/24 are used by block lists to
+// indicate errors, such as refused queries through public resolvers, and are not
+// treated as a listing.
+//
+// Hash lists are files with hexadecimal SHA-256 hashes, one per line. Empty lines
+// and lines starting with "#" are ignored. Text after the hash on a line, e.g. a
+// name of the malware, is ignored. Files are read again when they have been
+// modified.
+package contentbl
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/publicsuffix"
+	"github.com/mjl-/mox/stub"
+)
+
+var (
+	MetricLookup stub.HistogramVec = stub.HistogramVecIgnore{}
+)
+
+// ErrDNS is returned for temporary DNS errors during URI block list lookups.
+var ErrDNS = errors.New("contentbl: dns error")
+
+// Limits on the work done per message.
+const (
+	maxDomains     = 20
+	maxAttachments = 20
+	maxTextSize    = 1024 * 1024 // Per text/html part.
+)
+
+// URIListing is a domain from a URL in a message that is listed in a URI block
+// list.
+type URIListing struct {
+	Zone   dns.Domain
+	Domain dns.Domain
+}
+
+// HashListing is an attachment with its hash present in a hash list.
+type HashListing struct {
+	File string // Path of hash list file.
+	Hash string // Hexadecimal SHA-256.
+}
+
+// Result is the outcome of checking a message.
+type Result struct {
+	Domains     []dns.Domain // Organizational domains of URLs, as checked.
+	Hashes      []string     // Hexadecimal SHA-256 hashes of attachments, as checked.
+	URIListed   []URIListing
+	HashListed  []HashListing
+	URITemperr  bool // Whether URI block list lookups had temporary errors.
+	HashTemperr bool // Whether hash lists could not be read.
+}
+
+// Header returns an annotation header for the result, in the style of an
+// Authentication-Results header, with "uribl" and "hashbl" methods, ending with
+// CRLF. An empty string is returned if no checks were done.
+func (r Result) Header(hostname dns.Domain, uribl, hashbl bool) string {
+	if !uribl && !hashbl {
+		return ""
+	}
+	var l []string
+	if uribl {
+		var s string
+		if len(r.URIListed) > 0 {
+			var listed []string
+			for _, ul := range r.URIListed {
+				listed = append(listed, ul.Domain.ASCII+" in "+ul.Zone.ASCII)
+			}
+			s = fmt.Sprintf("uribl=fail (%s)", strings.Join(listed, ", "))
+		} else if r.URITemperr {
+			s = "uribl=temperror"
+		} else if len(r.Domains) == 0 {
+			s = "uribl=none"
+		} else {
+			s = fmt.Sprintf("uribl=pass (domains: %d)", len(r.Domains))
+		}
+		l = append(l, s)
+	}
+	if hashbl {
+		var s string
+		if len(r.HashListed) > 0 {
+			var listed []string
+			for _, hl := range r.HashListed {
+				listed = append(listed, hl.Hash)
+			}
+			s = fmt.Sprintf("hashbl=fail (%s)", strings.Join(listed, ", "))
+		} else if r.HashTemperr {
+			s = "hashbl=temperror"
+		} else if len(r.Hashes) == 0 {
+			s = "hashbl=none"
+		} else {
+			s = fmt.Sprintf("hashbl=pass (attachments: %d)", len(r.Hashes))
+		}
+		l = append(l, s)
+	}
+	return fmt.Sprintf("X-Mox-Content-Blocklists: %s;\r\n\t%s\r\n", hostname.ASCII, strings.Join(l, ";\r\n\t"))
+}
+
+// Check extracts domains of URLs and hashes of attachments from the message in
+// r, and looks them up in the URI block lists and hash list files.
+func Check(ctx context.Context, elog *slog.Logger, resolver dns.Resolver, zones []dns.Domain, hashFiles []string, r io.ReaderAt, size int64) (result Result) {
+	log := mlog.New("contentbl", elog)
+
+	p, err := message.EnsurePart(log.Logger, false, r, size)
+	if err != nil {
+		log.Debugx("parsing message for content block lists, continuing", err)
+	}
+	var attachments []message.Part
+	result.Domains, attachments = extract(ctx, log, p, len(zones) > 0, len(hashFiles) > 0)
+
+	for _, d := range result.Domains {
+		for _, zone := range zones {
+			status, err := Lookup(ctx, log.Logger, resolver, zone, d)
+			if err != nil {
+				result.URITemperr = true
+			} else if status {
+				result.URIListed = append(result.URIListed, URIListing{zone, d})
+			}
+		}
+	}
+
+	for _, ap := range attachments {
+		h := sha256.New()
+		if _, err := io.Copy(h, ap.Reader()); err != nil {
+			log.Debugx("reading attachment for hash, skipping", err)
+			continue
+		}
+		result.Hashes = append(result.Hashes, hex.EncodeToString(h.Sum(nil)))
+	}
+	if len(result.Hashes) > 0 {
+		for _, file := range hashFiles {
+			hashes, err := hashList(file)
+			if err != nil {
+				log.Errorx("reading hash list", err, slog.String("file", file))
+				result.HashTemperr = true
+				continue
+			}
+			for _, h := range result.Hashes {
+				if _, ok := hashes[h]; ok {
+					result.HashListed = append(result.HashListed, HashListing{file, h})
+				}
+			}
+		}
+	}
+
+	log.Debug("content block lists checked",
+		slog.Any("domains", result.Domains),
+		slog.Any("hashes", result.Hashes),
+		slog.Any("urilisted", result.URIListed),
+		slog.Any("hashlisted", result.HashListed))
+	return result
+}
+
+var urlRegexp = regexp.MustCompile(`(?i)(?:\bhttps?://|\bwww\.)([a-z0-9][a-z0-9.-]*\.[a-z][a-z0-9-]+)`)
+
+// extract returns the organizational domains of URLs in text and html parts, and
+// the attachments, i.e. parts that are not text, multipart or messages.
+func extract(ctx context.Context, log mlog.Log, p message.Part, domains, attachments bool) (rdomains []dns.Domain, rattachments []message.Part) {
+	seen := map[dns.Domain]struct{}{}
+
+	var walk func(p message.Part)
+	walk = func(p message.Part) {
+		if p.Message != nil {
+			if err := p.SetMessageReaderAt(); err != nil {
+				log.Debugx("setting reader on nested message, skipping", err)
+				return
+			}
+			walk(*p.Message)
+			return
+		}
+		if len(p.Parts) > 0 {
+			for _, sp := range p.Parts {
+				walk(sp)
+			}
+			return
+		}
+		if p.MediaType == "" || p.MediaType == "TEXT" {
+			if !domains || len(rdomains) >= maxDomains {
+				return
+			}
+			buf, err := io.ReadAll(io.LimitReader(p.ReaderUTF8OrBinary(), maxTextSize))
+			if err != nil {
+				log.Debugx("reading text part for urls, continuing", err)
+			}
+			for _, m := range urlRegexp.FindAllSubmatch(buf, -1) {
+				d, err := dns.ParseDomain(strings.ToLower(strings.TrimRight(string(m[1]), ".-")))
+				if err != nil {
+					continue
+				}
+				d = publicsuffix.Lookup(ctx, log.Logger, d)
+				if _, ok := seen[d]; ok {
+					continue
+				}
+				seen[d] = struct{}{}
+				rdomains = append(rdomains, d)
+				if len(rdomains) >= maxDomains {
+					return
+				}
+			}
+		} else if p.MediaType != "MULTIPART" && p.MediaType != "MESSAGE" && attachments && len(rattachments) < maxAttachments {
+			rattachments = append(rattachments, p)
+		}
+	}
+	walk(p)
+	return
+}
+
+// Lookup checks if the domain is listed in the URI block list zone.
+func Lookup(ctx context.Context, elog *slog.Logger, resolver dns.Resolver, zone, domain dns.Domain) (listed bool, rerr error) {
+	log := mlog.New("contentbl", elog)
+	start := time.Now()
+	defer func() {
+		status := "pass"
+		if rerr != nil {
+			status = "temperror"
+		} else if listed {
+			status = "fail"
+		}
+		MetricLookup.ObserveLabels(float64(time.Since(start))/float64(time.Second), zone.Name(), status)
+		log.Debugx("uribl lookup result", rerr,
+			slog.Any("zone", zone),
+			slog.Any("domain", domain),
+			slog.Bool("listed", listed),
+			slog.Duration("duration", time.Since(start)))
+	}()
+
+	name := domain.ASCII + "." + zone.ASCII + "."
+	ips, _, err := dns.WithPackage(resolver, "contentbl").LookupIP(ctx, "ip4", name)
+	if dns.IsNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("%w: %s", ErrDNS, err)
+	}
+	for _, ip := range ips {
+		ip4 := ip.To4()
+		if ip4 == nil || ip4.Equal(net.IPv4(127, 0, 0, 1)) || ip4[0] == 127 && ip4[1] == 255 && ip4[2] == 255 {
+			return false, fmt.Errorf("%w: error response %s from block list", ErrDNS, ip)
+		}
+	}
+	return len(ips) > 0, nil
+}
+
+var hashLists = struct {
+	sync.Mutex
+	files map[string]hashListFile
+}{files: map[string]hashListFile{}}
+
+type hashListFile struct {
+	modTime time.Time
+	size    int64
+	hashes  map[string]struct{}
+}
+
+// hashList returns the hashes in file, reading it again if it was modified.
+func hashList(file string) (map[string]struct{}, error) {
+	fi, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+
+	hashLists.Lock()
+	defer hashLists.Unlock()
+	if hf, ok := hashLists.files[file]; ok && hf.modTime.Equal(fi.ModTime()) && hf.size == fi.Size() {
+		return hf.hashes, nil
+	}
+
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	hashes := map[string]struct{}{}
+	for _, line := range strings.Split(string(buf), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		h := strings.ToLower(strings.Fields(line)[0])
+		if len(h) != 2*sha256.Size {
+			continue
+		}
+		if _, err := hex.DecodeString(h); err != nil {
+			continue
+		}
+		hashes[h] = struct{}{}
+	}
+	hashLists.files[file] = hashListFile{fi.ModTime(), fi.Size(), hashes}
+	return hashes, nil
+}
@@ -0,0 +1,100 @@
+package contentbl
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/mlog"
+)
+
+func TestCheck(t *testing.T) {
+	ctx := context.Background()
+	log := mlog.New("contentbl", nil)
+
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"bad.example.uribl.example.":     {"127.0.0.2"},
+			"refused.example.uribl.example.": {"127.0.0.1"},
+		},
+	}
+	zone := dns.Domain{ASCII: "uribl.example"}
+
+	if listed, err := Lookup(ctx, log.Logger, resolver, zone, dns.Domain{ASCII: "bad.example"}); err != nil || !listed {
+		t.Fatalf("lookup listed domain, got listed %v, err %v", listed, err)
+	}
+	if listed, err := Lookup(ctx, log.Logger, resolver, zone, dns.Domain{ASCII: "good.example"}); err != nil || listed {
+		t.Fatalf("lookup unlisted domain, got listed %v, err %v", listed, err)
+	}
+	if _, err := Lookup(ctx, log.Logger, resolver, zone, dns.Domain{ASCII: "refused.example"}); err == nil {
+		t.Fatalf("lookup with error response, expected error")
+	}
+
+	const attachment = "malware!"
+	sum := sha256.Sum256([]byte(attachment))
+	hash := hex.EncodeToString(sum[:])
+	hashFile := filepath.Join(t.TempDir(), "hashes.txt")
+	err := os.WriteFile(hashFile, []byte("# test list\n\n"+strings.ToUpper(hash)+" test-malware\n"), 0660)
+	if err != nil {
+		t.Fatalf("write hash file: %v", err)
+	}
+
+	msg := strings.ReplaceAll(`From: <remote@example.org>
+Subject: test
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary=x
+
+--x
+Content-Type: text/plain
+
+Visit https://www.bad.example/path and www.good.example, or http://good.example.
+--x
+Content-Type: text/html
+
+<a href="https://sub.other.example/">link</a>
+--x
+Content-Type: application/octet-stream
+Content-Transfer-Encoding: base64
+
+bWFsd2FyZSE=
+--x--
+`, "\n", "\r\n")
+
+	r := Check(ctx, log.Logger, resolver, []dns.Domain{zone}, []string{hashFile}, strings.NewReader(msg), int64(len(msg)))
+	var domains []string
+	for _, d := range r.Domains {
+		domains = append(domains, d.ASCII)
+	}
+	if strings.Join(domains, ",") != "bad.example,good.example,other.example" {
+		t.Fatalf("got domains %v", domains)
+	}
+	if len(r.URIListed) != 1 || r.URIListed[0].Domain.ASCII != "bad.example" || r.URITemperr {
+		t.Fatalf("got uri listings %v, temperr %v, expected bad.example", r.URIListed, r.URITemperr)
+	}
+	if len(r.Hashes) != 1 || len(r.HashListed) != 1 || r.HashListed[0].Hash != hash {
+		t.Fatalf("got hashes %v, hash listings %v, expected %s", r.Hashes, r.HashListed, hash)
+	}
+
+	hdr := r.Header(dns.Domain{ASCII: "mox.example"}, true, true)
+	if !strings.HasPrefix(hdr, "X-Mox-Content-Blocklists: mox.example;") || !strings.Contains(hdr, "uribl=fail (bad.example in uribl.example)") || !strings.Contains(hdr, "hashbl=fail ("+hash+")") {
+		t.Fatalf("unexpected header %q", hdr)
+	}
+
+	// Updated hash list is read again.
+	err = os.WriteFile(hashFile, []byte("# empty\n"), 0660)
+	if err != nil {
+		t.Fatalf("write hash file: %v", err)
+	}
+	r = Check(ctx, log.Logger, resolver, nil, []string{hashFile}, strings.NewReader(msg), int64(len(msg)))
+	if len(r.Domains) != 0 || len(r.HashListed) != 0 {
+		t.Fatalf("got domains %v, hash listings %v, expected none", r.Domains, r.HashListed)
+	}
+	if hdr := r.Header(dns.Domain{ASCII: "mox.example"}, false, true); hdr != "X-Mox-Content-Blocklists: mox.example;\r\n\thashbl=pass (attachments: 1)\r\n" {
+		t.Fatalf("unexpected header %q", hdr)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -14,6 +15,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime/debug"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -21,13 +23,18 @@ import (
 
 	"github.com/mjl-/bstore"
 
+	"github.com/mjl-/mox/accounting"
+	"github.com/mjl-/mox/admindb"
 	"github.com/mjl-/mox/config"
 	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/dnsbl"
+	"github.com/mjl-/mox/health"
 	"github.com/mjl-/mox/message"
 	"github.com/mjl-/mox/metrics"
 	"github.com/mjl-/mox/mlog"
 	"github.com/mjl-/mox/mox-"
 	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/replication"
 	"github.com/mjl-/mox/smtp"
 	"github.com/mjl-/mox/store"
 	"github.com/mjl-/mox/webapi"
@@ -41,6 +48,10 @@ type ctl struct {
 	r    *bufio.Reader // Set for first reader.
 	x    any           // If set, errors are handled by calling panic(x) instead of log.Fatal.
 	log  mlog.Log      // If set, along with x, logging is done here.
+
+	// For server-side of commands, for the audit log.
+	args   []string // Parameters read for the current command.
+	errmsg string   // Error for the current command, if any.
 }
 
 // xctl opens a ctl connection.
@@ -65,6 +76,7 @@ func (c *ctl) xerror(msg string) {
 		log.Fatalln(msg)
 	}
 	c.log.Debugx("ctl error", fmt.Errorf("%s", msg), slog.String("cmd", c.cmd))
+	c.errmsg = msg
 	c.xwrite(msg)
 	panic(c.x)
 }
@@ -80,6 +92,7 @@ func (c *ctl) xcheck(err error, msg string) {
 		log.Fatalf("%s: %s", msg, err)
 	}
 	c.log.Debugx(msg, err, slog.String("cmd", c.cmd))
+	c.errmsg = fmt.Sprintf("%s: %s", msg, err)
 	fmt.Fprintf(c.conn, "%s: %s\n", msg, err)
 	panic(c.x)
 }
@@ -91,7 +104,11 @@ func (c *ctl) xread() string {
 	}
 	line, err := c.r.ReadString('\n')
 	c.xcheck(err, "read from ctl")
-	return strings.TrimSuffix(line, "\n")
+	line = strings.TrimSuffix(line, "\n")
+	if c.cmd != "" {
+		c.args = append(c.args, line)
+	}
+	return line
 }
 
 // Read a line. If not "ok", the string is interpreted as an error.
@@ -305,11 +322,85 @@ func xparseJSON(ctl *ctl, s string, v any) {
 	ctl.xcheck(err, "parsing from ctl as json")
 }
 
+// ctlAudited are the commands that make changes, recorded in the audit log.
+var ctlAudited = map[string]bool{
+	"setaccountpassword":   true,
+	"queueholdrulesadd":    true,
+	"queueholdrulesremove": true,
+	"queueholdset":         true,
+	"queueschedule":        true,
+	"queuetransport":       true,
+	"queuerequiretls":      true,
+	"queuefail":            true,
+	"queuedrop":            true,
+	"queuehookschedule":    true,
+	"queuehookcancel":      true,
+	"queuesuppressadd":     true,
+	"queuesuppressremove":  true,
+	"domainadd":            true,
+	"domainrm":             true,
+	"accountadd":           true,
+	"accountrm":            true,
+	"accounttakeout":       true,
+	"accountdeleterequest": true,
+	"accountdeletecancel":  true,
+	"accountdeletepurge":   true,
+	"addressadd":           true,
+	"addressrm":            true,
+	"aliasadd":             true,
+	"aliasupdate":          true,
+	"aliasrm":              true,
+	"aliasaddaddr":         true,
+	"aliasrmaddr":          true,
+	"apitokenadd":          true,
+	"apitokenrm":           true,
+	"setloglevels":         true,
+	"reload":               true,
+	"configapply":          true,
+	"checkreceive":         true,
+	"reindex":              true,
+}
+
+// audit adds an entry for the current command to the audit log. Called through
+// defer, also when the command failed.
+func (c *ctl) audit() {
+	args := c.args
+	if c.cmd == "setaccountpassword" && len(args) > 1 {
+		args = append([]string{}, args...)
+		args[1] = "***"
+	} else if c.cmd == "accountdeletepurge" && len(args) > 0 {
+		// The purge redacts earlier references to the account.
+		args = append([]string{}, args...)
+		args[0] = "***"
+	}
+	params, err := json.Marshal(args)
+	c.log.Check(err, "marshal ctl parameters for audit log")
+	e := admindb.AuditEntry{
+		Source: "ctl",
+		Action: c.cmd,
+		Params: string(params),
+		Error:  c.errmsg,
+	}
+	err = admindb.AuditAdd(context.Background(), &e)
+	c.log.Check(err, "adding ctl command to audit log")
+}
+
 func servectlcmd(ctx context.Context, ctl *ctl, shutdown func()) {
 	log := ctl.log
+	ctl.cmd = ""
 	cmd := ctl.xread()
 	ctl.cmd = cmd
-	log.Info("ctl command", slog.String("cmd", cmd))
+	ctl.args = nil
+	ctl.errmsg = ""
+	if cmd == "top" {
+		// Sent every few seconds by "mox top".
+		log.Debug("ctl command", slog.String("cmd", cmd))
+	} else {
+		log.Info("ctl command", slog.String("cmd", cmd))
+	}
+	if ctlAudited[cmd] {
+		defer ctl.audit()
+	}
 	switch cmd {
 	case "stop":
 		shutdown()
@@ -365,6 +456,9 @@ func servectlcmd(ctx context.Context, ctl *ctl, shutdown func()) {
 		account := ctl.xread()
 		pw := ctl.xread()
 
+		err := mox.PasswordPolicyCheck(ctx, log, account, pw)
+		ctl.xcheck(err, "checking password")
+
 		acc, err := store.OpenAccount(log, account)
 		ctl.xcheck(err, "open account")
 		defer func() {
@@ -937,9 +1031,23 @@ func servectlcmd(ctx context.Context, ctl *ctl, shutdown func()) {
 		}
 		xw.xclose()
 
-	case "importmaildir", "importmbox":
-		mbox := cmd == "importmbox"
-		importctl(ctx, ctl, mbox)
+	case "importmaildir", "importmbox", "importeml", "importpst":
+		importctl(ctx, ctl, strings.TrimPrefix(cmd, "import"))
+
+	case "importimap":
+		importimapctl(ctx, ctl)
+
+	case "restore":
+		restorectl(ctx, ctl)
+
+	case "fsck":
+		fsckctl(ctx, ctl)
+
+	case "accounttakeout":
+		accounttakeoutctl(ctx, ctl)
+
+	case "accountdeleterequest", "accountdeletecancel", "accountdeletepurge", "accountdeletelist":
+		accountdeletectl(ctx, ctl, cmd)
 
 	case "domainadd":
 		/* protocol:
@@ -1019,6 +1127,56 @@ func servectlcmd(ctx context.Context, ctl *ctl, shutdown func()) {
 		ctl.xcheck(err, "removing address")
 		ctl.xwriteok()
 
+	case "addresslist":
+		/* protocol:
+		> "addresslist"
+		> domains, space-separated, empty for all
+		< "ok" or error
+		< stream
+		*/
+		var domains []dns.Domain
+		if line := ctl.xread(); line != "" {
+			for _, s := range strings.Split(line, " ") {
+				d, err := dns.ParseDomain(s)
+				ctl.xcheck(err, "parsing domain")
+				if _, ok := mox.Conf.Domain(d); !ok {
+					ctl.xcheck(errors.New("no such domain"), "listing addresses")
+				}
+				domains = append(domains, d)
+			}
+		} else {
+			for _, name := range mox.Conf.Domains() {
+				d, err := dns.ParseDomain(name)
+				ctl.xcheck(err, "parsing domain")
+				domains = append(domains, d)
+			}
+		}
+		ctl.xwriteok()
+		w := ctl.writer()
+		for _, d := range domains {
+			localparts, aliases := mox.Conf.DomainLocalparts(d)
+			var l []string
+			for lp := range localparts {
+				if lp == "" {
+					l = append(l, "@"+d.Name())
+					continue
+				}
+				plp, err := smtp.ParseLocalpart(lp)
+				ctl.xcheck(err, "parsing localpart")
+				l = append(l, smtp.NewAddress(plp, d).Pack(true))
+			}
+			for lp := range aliases {
+				plp, err := smtp.ParseLocalpart(lp)
+				ctl.xcheck(err, "parsing alias localpart")
+				l = append(l, smtp.NewAddress(plp, d).Pack(true))
+			}
+			sort.Strings(l)
+			for _, s := range l {
+				fmt.Fprintln(w, s)
+			}
+		}
+		w.xclose()
+
 	case "aliaslist":
 		/* protocol:
 		> "aliaslist"
@@ -1178,6 +1336,288 @@ func servectlcmd(ctx context.Context, ctl *ctl, shutdown func()) {
 		ctl.xcheck(err, "removing addresses to alias")
 		ctl.xwriteok()
 
+	case "apitokenlist":
+		/* protocol:
+		> "apitokenlist"
+		< "ok" or error
+		< stream
+		*/
+		l, err := admindb.TokenList(ctx)
+		ctl.xcheck(err, "listing api tokens")
+		ctl.xwriteok()
+		w := ctl.writer()
+		for _, t := range l {
+			lastUsed := "-"
+			if !t.LastUsed.IsZero() {
+				lastUsed = t.LastUsed.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%s\t%s\tcreated %s\tlast used %s\n", t.Name, t.Role, t.Created.Format(time.RFC3339), lastUsed)
+		}
+		w.xclose()
+
+	case "apitokenadd":
+		/* protocol:
+		> "apitokenadd"
+		> name
+		> role
+		< "ok" or error
+		< stream
+		*/
+		name := ctl.xread()
+		role := ctl.xread()
+		token, _, err := admindb.TokenAdd(ctx, name, admindb.Role(role))
+		ctl.xcheck(err, "adding api token")
+		ctl.xwriteok()
+		w := ctl.writer()
+		fmt.Fprintln(w, token)
+		w.xclose()
+
+	case "apitokenrm":
+		/* protocol:
+		> "apitokenrm"
+		> name
+		< "ok" or error
+		*/
+		name := ctl.xread()
+		err := admindb.TokenRemove(ctx, name)
+		ctl.xcheck(err, "removing api token")
+		ctl.xwriteok()
+
+	case "configapply":
+		/* protocol:
+		> "configapply"
+		> dryrun ("true" or "false")
+		> stream with desired domains.conf
+		< "ok" or error
+		< stream
+		*/
+		dryRun := ctl.xread() == "true"
+		var buf bytes.Buffer
+		ctl.xstreamto(&buf)
+		changes, err := mox.ConfigApply(ctx, log, buf.Bytes(), dryRun)
+		ctl.xcheck(err, "applying config")
+		ctl.xwriteok()
+		w := ctl.writer()
+		if len(changes) == 0 {
+			fmt.Fprintln(w, "no changes")
+		}
+		for _, c := range changes {
+			fmt.Fprintln(w, c.String())
+		}
+		if len(changes) > 0 && dryRun {
+			fmt.Fprintln(w, "dry run, not applied")
+		}
+		w.xclose()
+
+	case "healthcheck":
+		/* protocol:
+		> "healthcheck"
+		> live ("true" or "false")
+		< "ok" or error
+		< "healthy" or "unhealthy"
+		< stream
+		*/
+		live := ctl.xread() == "true"
+		l := health.Check(ctx, log, !live)
+		ctl.xwriteok()
+		if health.OK(l) {
+			ctl.xwrite("healthy")
+		} else {
+			ctl.xwrite("unhealthy")
+		}
+		w := ctl.writer()
+		health.Write(w, l)
+		w.xclose()
+
+	case "reload":
+		/* protocol:
+		> "reload"
+		> preview ("true" or "false")
+		< "ok" or error
+		< stream
+		*/
+		preview := ctl.xread() == "true"
+		r, err := mox.Conf.ReloadStatic(ctx, log, !preview)
+		ctl.xcheck(err, "reloading config file")
+		ctl.xwriteok()
+		w := ctl.writer()
+		// Empty writes would end the stream.
+		if r.Diff != "" {
+			fmt.Fprint(w, r.Diff)
+		} else if len(r.Changed) == 0 && len(r.Restart) == 0 {
+			fmt.Fprintln(w, "no changes")
+		}
+		if len(r.Changed) > 0 {
+			if r.Applied {
+				fmt.Fprintf(w, "applied: %s\n", strings.Join(r.Changed, ", "))
+			} else {
+				fmt.Fprintf(w, "would apply: %s\n", strings.Join(r.Changed, ", "))
+			}
+		}
+		if len(r.Restart) > 0 {
+			fmt.Fprintf(w, "restart required for: %s\n", strings.Join(r.Restart, ", "))
+		}
+		w.xclose()
+
+	case "top":
+		/* protocol:
+		> "top"
+		> max queue messages
+		> max events
+		< "ok" or error
+		< snapshot as json
+		*/
+		maxQueue, err := strconv.Atoi(ctl.xread())
+		ctl.xcheck(err, "parsing max queue messages")
+		maxEvents, err := strconv.Atoi(ctl.xread())
+		ctl.xcheck(err, "parsing max events")
+		snapshot, err := topGather(ctx, maxQueue, maxEvents)
+		ctl.xcheck(err, "gathering snapshot")
+		buf, err := json.Marshal(snapshot)
+		ctl.xcheck(err, "marshal snapshot")
+		ctl.xwriteok()
+		ctl.xwrite(string(buf))
+
+	case "checkreceive":
+		/* protocol:
+		> "checkreceive"
+		> from address
+		> echo address
+		> timeout
+		< "ok" or error
+		< stream
+		< "pass" or "fail"
+		*/
+		from, err := smtp.ParseAddress(ctl.xread())
+		ctl.xcheck(err, "parsing from address")
+		to, err := smtp.ParseAddress(ctl.xread())
+		ctl.xcheck(err, "parsing echo address")
+		timeout, err := time.ParseDuration(ctl.xread())
+		ctl.xcheck(err, "parsing timeout")
+		accName, _, _, _, err := mox.LookupAddress(from.Localpart, from.Domain, false, false)
+		ctl.xcheck(err, "looking up account for from address")
+		if _, ok := mox.Conf.Domain(to.Domain); ok {
+			ctl.xerror(fmt.Sprintf("echo address must be external, domain %s is configured in this mox", to.Domain))
+		}
+		ctl.xwriteok()
+		w := ctl.writer()
+		pass, err := checkreceive(ctx, log, w, accName, from, to, timeout)
+		if err != nil {
+			fmt.Fprintf(w, "error: %v\n", err)
+		}
+		w.xclose()
+		if pass {
+			ctl.xwrite("pass")
+		} else {
+			ctl.xwrite("fail")
+		}
+
+	case "dnsblmonitor":
+		/* protocol:
+		> "dnsblmonitor"
+		> check ("true" or "false")
+		> max history
+		< "ok" or error
+		< stream
+		*/
+		check := ctl.xread() == "true"
+		maxHistory, err := strconv.Atoi(ctl.xread())
+		ctl.xcheck(err, "parsing max history")
+		zones := dnsblMonitorZones()
+		ips, err := dnsblMonitorIPs(ctx)
+		ctl.xcheck(err, "listing ips")
+		if check {
+			resolver := dns.StrictResolver{Pkg: "dnsblmonitor"}
+			for _, ip := range ips {
+				for _, zone := range zones {
+					_, _, err := dnsblMonitorLookup(ctx, log, resolver, zone, ip)
+					log.Check(err, "dnsbl lookup", slog.Any("zone", zone), slog.Any("ip", ip))
+				}
+			}
+		}
+		history, err := admindb.DNSBLChangeList(ctx, maxHistory)
+		ctl.xcheck(err, "listing dnsbl history")
+		ctl.xwriteok()
+		w := ctl.writer()
+		if len(zones) == 0 {
+			fmt.Fprintln(w, "No DNSBLs configured, see DNSBLs of SMTP listeners in mox.conf and MonitorDNSBLs in domains.conf.")
+		}
+		var listed []string
+		for _, ip := range ips {
+			for _, zone := range zones {
+				c, err := admindb.DNSBLStatus(ctx, zone.Name(), ip.String())
+				if err == admindb.ErrNotFound {
+					fmt.Fprintf(w, "%s\t%s\tnot checked yet\n", ip, zone)
+					continue
+				}
+				ctl.xcheck(err, "looking up dnsbl status")
+				if c.Listed {
+					fmt.Fprintf(w, "%s\t%s\tlisted since %s\t%q\n", ip, zone, c.Time.Format(time.RFC3339), c.Explanation)
+					listed = append(listed, dnsbl.DelistText(zone, ip.String()))
+				} else {
+					fmt.Fprintf(w, "%s\t%s\tnot listed since %s\n", ip, zone, c.Time.Format(time.RFC3339))
+				}
+			}
+		}
+		for _, text := range listed {
+			fmt.Fprintf(w, "\n%s\n", text)
+		}
+		if len(history) > 0 {
+			fmt.Fprintf(w, "\nHistory, most recent first:\n")
+		}
+		for _, c := range history {
+			status := "not listed"
+			if c.Listed {
+				status = "listed"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s", c.Time.Format(time.RFC3339), c.IP, c.Zone, status)
+			if c.Explanation != "" {
+				fmt.Fprintf(w, "\t%q", c.Explanation)
+			}
+			fmt.Fprintln(w)
+		}
+		w.xclose()
+
+	case "usage":
+		/* protocol:
+		> "usage"
+		> start month
+		> end month
+		> account
+		> domain
+		> group
+		< "ok" or error
+		< stream with usage as json
+		*/
+		f := admindb.UsageFilter{Start: ctl.xread(), End: ctl.xread(), Account: ctl.xread(), Domain: ctl.xread()}
+		group := ctl.xread()
+		l, err := accounting.List(ctx, f, group)
+		ctl.xcheck(err, "listing usage")
+		buf, err := json.Marshal(l)
+		ctl.xcheck(err, "marshal usage")
+		ctl.xwriteok()
+		ctl.xstreamfrom(bytes.NewReader(buf))
+
+	case "messagetrace":
+		/* protocol:
+		> "messagetrace"
+		> id
+		< "ok" or error
+		< stream
+		*/
+		id := ctl.xread()
+		l, err := admindb.MessageEventList(ctx, id)
+		ctl.xcheck(err, "listing message events")
+		ctl.xwriteok()
+		w := ctl.writer()
+		for _, e := range l {
+			fmt.Fprintln(w, messageEventLine(e))
+		}
+		if len(l) == 0 {
+			fmt.Fprintln(w, "(none)")
+		}
+		w.xclose()
+
 	case "loglevels":
 		/* protocol:
 		> "loglevels"
@@ -1287,6 +1727,25 @@ func servectlcmd(ctx context.Context, ctl *ctl, shutdown func()) {
 		})
 		ctl.xwriteok()
 
+	case "retrainbacklog":
+		/* protocol:
+		> "retrainbacklog"
+		> account
+		< "ok" or error
+		< number of retrained messages
+		*/
+		account := ctl.xread()
+		acc, err := store.OpenAccount(log, account)
+		ctl.xcheck(err, "open account")
+		defer func() {
+			err := acc.Close()
+			log.Check(err, "closing account after retraining backlog")
+		}()
+		n, err := acc.RetrainBacklog(ctx, log)
+		ctl.xcheck(err, "retraining backlog")
+		ctl.xwriteok()
+		ctl.xwrite(fmt.Sprintf("%d", n))
+
 	case "recalculatemailboxcounts":
 		/* protocol:
 		> "recalculatemailboxcounts"
@@ -1306,52 +1765,8 @@ func servectlcmd(ctx context.Context, ctl *ctl, shutdown func()) {
 		ctl.xwriteok()
 
 		w := ctl.writer()
-
-		acc.WithWLock(func() {
-			var changes []store.Change
-			err = acc.DB.Write(ctx, func(tx *bstore.Tx) error {
-				var totalSize int64
-				err := bstore.QueryTx[store.Mailbox](tx).ForEach(func(mb store.Mailbox) error {
-					mc, err := mb.CalculateCounts(tx)
-					if err != nil {
-						return fmt.Errorf("calculating counts for mailbox %q: %w", mb.Name, err)
-					}
-					totalSize += mc.Size
-
-					if !mb.HaveCounts || mc != mb.MailboxCounts {
-						_, err := fmt.Fprintf(w, "for %s setting new counts %s (was %s)\n", mb.Name, mc, mb.MailboxCounts)
-						ctl.xcheck(err, "write")
-						mb.HaveCounts = true
-						mb.MailboxCounts = mc
-						if err := tx.Update(&mb); err != nil {
-							return fmt.Errorf("storing new counts for %q: %v", mb.Name, err)
-						}
-						changes = append(changes, mb.ChangeCounts())
-					}
-					return nil
-				})
-				if err != nil {
-					return err
-				}
-
-				du := store.DiskUsage{ID: 1}
-				if err := tx.Get(&du); err != nil {
-					return fmt.Errorf("get disk usage: %v", err)
-				}
-				if du.MessageSize != totalSize {
-					_, err := fmt.Fprintf(w, "setting new total message size %d (was %d)\n", totalSize, du.MessageSize)
-					ctl.xcheck(err, "write")
-					du.MessageSize = totalSize
-					if err := tx.Update(&du); err != nil {
-						return fmt.Errorf("update disk usage: %v", err)
-					}
-				}
-				return nil
-			})
-			ctl.xcheck(err, "write transaction for mailbox counts")
-
-			store.BroadcastChanges(acc, changes)
-		})
+		err = acc.RecalculateMailboxCounts(ctx, w)
+		ctl.xcheck(err, "recalculating mailbox counts")
 		w.xclose()
 
 	case "fixmsgsize":
@@ -1397,7 +1812,7 @@ func servectlcmd(ctx context.Context, ctl *ctl, shutdown func()) {
 							n++
 
 							p := acc.MessagePath(m.ID)
-							st, err := os.Stat(p)
+							filesize, err := store.MessageFileSize(p, store.MessageKey(acc.Name), m.FileEncrypted, m.FileCompressed)
 							if err != nil {
 								mb := store.Mailbox{ID: m.MailboxID}
 								if xerr := tx.Get(&mb); xerr != nil {
@@ -1408,8 +1823,7 @@ func servectlcmd(ctx context.Context, ctl *ctl, shutdown func()) {
 								ctl.xcheck(werr, "write")
 								return nil
 							}
-							filesize := st.Size()
-							correctSize := int64(len(m.MsgPrefix)) + filesize
+							correctSize := int64(len(m.Sealed.MsgPrefix)) + filesize
 							if m.Size == correctSize {
 								return nil
 							}
@@ -1421,7 +1835,7 @@ func servectlcmd(ctx context.Context, ctl *ctl, shutdown func()) {
 								_, werr := fmt.Fprintf(w, "get mailbox id %d for message with file size mismatch: %v\n", mb.ID, err)
 								ctl.xcheck(werr, "write")
 							}
-							_, err = fmt.Fprintf(w, "fixing message %d in mailbox %q (id %d) with incorrect size %d, should be %d (len msg prefix %d + on-disk file %s size %d)\n", m.ID, mb.Name, mb.ID, m.Size, correctSize, len(m.MsgPrefix), p, filesize)
+							_, err = fmt.Fprintf(w, "fixing message %d in mailbox %q (id %d) with incorrect size %d, should be %d (len msg prefix %d + on-disk file %s size %d)\n", m.ID, mb.Name, mb.ID, m.Size, correctSize, len(m.Sealed.MsgPrefix), p, filesize)
 							ctl.xcheck(err, "write")
 
 							// We assume that the original message size was accounted as stored in the mailbox
@@ -1442,7 +1856,7 @@ func servectlcmd(ctx context.Context, ctl *ctl, shutdown func()) {
 								_, werr := fmt.Fprintf(w, "parsing message %d again: %v (continuing)\n", m.ID, err)
 								ctl.xcheck(werr, "write")
 							}
-							m.ParsedBuf, err = json.Marshal(part)
+							m.Sealed.ParsedBuf, err = json.Marshal(part)
 							if err != nil {
 								return fmt.Errorf("marshal parsed message: %v", err)
 							}
@@ -1531,7 +1945,7 @@ func servectlcmd(ctx context.Context, ctl *ctl, shutdown func()) {
 							_, err := fmt.Fprintf(w, "parsing message %d: %v (continuing)\n", m.ID, err)
 							ctl.xcheck(err, "write")
 						}
-						m.ParsedBuf, err = json.Marshal(p)
+						m.Sealed.ParsedBuf, err = json.Marshal(p)
 						if err != nil {
 							return fmt.Errorf("marshal parsed message: %v", err)
 						}
@@ -1624,6 +2038,225 @@ func servectlcmd(ctx context.Context, ctl *ctl, shutdown func()) {
 		}
 		w.xclose()
 
+	case "reindex":
+		/* protocol:
+		> "reindex"
+		> account or empty
+		> steps, comma-separated
+		> restart ("true" or "false")
+		< "ok" or error
+		< stream
+		*/
+
+		accountOpt := ctl.xread()
+		steps := strings.Split(ctl.xread(), ",")
+		restart := ctl.xread() == "true"
+		for _, s := range steps {
+			if !slices.Contains(store.ReindexSteps, s) {
+				ctl.xerror(fmt.Sprintf("unknown step %q, valid steps: %s", s, strings.Join(store.ReindexSteps, ", ")))
+			}
+		}
+		ctl.xwriteok()
+		w := ctl.writer()
+
+		xreindex := func(accName string) {
+			acc, err := store.OpenAccount(log, accName)
+			ctl.xcheck(err, "open account")
+			defer func() {
+				err := acc.Close()
+				log.Check(err, "closing account after reindexing")
+			}()
+			err = acc.Reindex(ctx, log, steps, restart, w)
+			ctl.xcheck(err, "reindexing")
+		}
+
+		if accountOpt != "" {
+			xreindex(accountOpt)
+		} else {
+			for i, accName := range mox.Conf.Accounts() {
+				var line string
+				if i > 0 {
+					line = "\n"
+				}
+				_, err := fmt.Fprintf(w, "%sReindexing account %s...\n", line, accName)
+				ctl.xcheck(err, "write")
+				xreindex(accName)
+			}
+		}
+		if slices.Contains(steps, "threads") {
+			_, err := fmt.Fprintf(w, "\nThreads were reassigned. You should invalidate messages stored at imap clients with the \"mox bumpuidvalidity account [mailbox]\" command.\n")
+			ctl.xcheck(err, "write")
+		}
+		w.xclose()
+
+	case "upgradeaccounts":
+		/* protocol:
+		> "upgradeaccounts"
+		> account or empty
+		< "ok" or error
+		< stream
+		*/
+
+		accountOpt := ctl.xread()
+		ctl.xwriteok()
+		w := ctl.writer()
+
+		xupgrade := func(accName string) {
+			// Opening an account applies database schema changes and starts one-time
+			// upgrades in the background.
+			start := time.Now()
+			acc, err := store.OpenAccount(log, accName)
+			ctl.xcheck(err, "open account")
+			defer func() {
+				err := acc.Close()
+				log.Check(err, "closing account after upgrade")
+			}()
+			err = acc.UpgradeWait(ctx, w, 5*time.Second)
+			ctl.xcheck(err, "upgrading account")
+			ri, ok, err := acc.ReindexStatus(ctx)
+			ctl.xcheck(err, "reading reindex status")
+			_, err = fmt.Fprintf(w, "account %s up to date, in %s\n", accName, time.Since(start).Round(time.Millisecond))
+			ctl.xcheck(err, "write")
+			if ok {
+				_, err := fmt.Fprintf(w, "account %s has an interrupted reindex with remaining steps %s, resume with \"mox reindex %s\"\n", accName, strings.Join(ri.Steps, ","), accName)
+				ctl.xcheck(err, "write")
+			}
+		}
+
+		if accountOpt != "" {
+			xupgrade(accountOpt)
+		} else {
+			for _, accName := range mox.Conf.Accounts() {
+				xupgrade(accName)
+			}
+		}
+		w.xclose()
+
+	case "compressmessages":
+		/* protocol:
+		> "compressmessages"
+		> account or empty
+		< "ok" or error
+		< stream
+		*/
+
+		accountOpt := ctl.xread()
+		ctl.xwriteok()
+		w := ctl.writer()
+
+		xcompressMessages := func(accName string) {
+			acc, err := store.OpenAccount(log, accName)
+			ctl.xcheck(err, "open account")
+			defer func() {
+				err := acc.Close()
+				log.Check(err, "closing account after compressing messages")
+			}()
+
+			stats, err := acc.CompressMessageFiles(ctx, log)
+			if err != nil {
+				_, werr := fmt.Fprintf(w, "%d message file(s) compressed before error\n", stats.Compressed)
+				ctl.xcheck(werr, "write")
+			}
+			ctl.xcheck(err, "compressing message files")
+
+			var pct int64
+			if stats.Size > 0 {
+				pct = 100 * (stats.Size - stats.DiskAfter) / stats.Size
+			}
+			_, err = fmt.Fprintf(w, "%d message file(s) compressed, %d in total\nmessage size %d bytes, on disk %d bytes before, %d bytes now, saving %d bytes (%d%%)\n", stats.Compressed, stats.Messages, stats.Size, stats.DiskBefore, stats.DiskAfter, stats.Size-stats.DiskAfter, pct)
+			ctl.xcheck(err, "write")
+		}
+
+		if accountOpt != "" {
+			xcompressMessages(accountOpt)
+		} else {
+			for i, accName := range mox.Conf.Accounts() {
+				var line string
+				if i > 0 {
+					line = "\n"
+				}
+				_, err := fmt.Fprintf(w, "%sCompressing message files of account %s...\n", line, accName)
+				ctl.xcheck(err, "write")
+				xcompressMessages(accName)
+			}
+		}
+		w.xclose()
+
+	case "dedup":
+		/* protocol:
+		> "dedup"
+		> account or empty
+		< "ok" or error
+		< stream
+		*/
+
+		accountOpt := ctl.xread()
+		ctl.xwriteok()
+		w := ctl.writer()
+
+		xdedup := func(accName string) {
+			acc, err := store.OpenAccount(log, accName)
+			ctl.xcheck(err, "open account")
+			defer func() {
+				err := acc.Close()
+				log.Check(err, "closing account after deduplicating messages")
+			}()
+
+			stats, err := acc.DedupMessageFiles(ctx, log)
+			if err != nil {
+				_, werr := fmt.Fprintf(w, "%d message file(s) replaced before error\n", stats.Replaced)
+				ctl.xcheck(werr, "write")
+			}
+			ctl.xcheck(err, "deduplicating message files")
+
+			_, err = fmt.Fprintf(w, "%d message file(s) replaced with link to identical file, %d in total\nsize on disk %d bytes, attributed to account %d bytes\n", stats.Replaced, stats.Messages, stats.Size, stats.Attributed)
+			ctl.xcheck(err, "write")
+		}
+
+		if accountOpt != "" {
+			xdedup(accountOpt)
+		} else {
+			for i, accName := range mox.Conf.Accounts() {
+				var line string
+				if i > 0 {
+					line = "\n"
+				}
+				_, err := fmt.Fprintf(w, "%sDeduplicating message files of account %s...\n", line, accName)
+				ctl.xcheck(err, "write")
+				xdedup(accName)
+			}
+		}
+
+		removed, size, err := store.DedupCleanup(log)
+		ctl.xcheck(err, "removing unused blobs")
+		_, err = fmt.Fprintf(w, "\n%d unused blob(s) removed, %d bytes\n", removed, size)
+		ctl.xcheck(err, "write")
+		w.xclose()
+
+	case "replicationstatus":
+		/* protocol:
+		> "replicationstatus"
+		< "ok"
+		< stream
+		*/
+		ctl.xwriteok()
+		w := ctl.writer()
+		if mox.Conf.Static.Replication == nil {
+			fmt.Fprintln(w, "replication not configured")
+		}
+		l := replication.Status()
+		for _, st := range l {
+			synced := "(none)"
+			if !st.LastSynced.IsZero() {
+				synced = fmt.Sprintf("%s, lag %s", st.LastSynced.Format(time.RFC3339), time.Since(st.LastSynced).Round(time.Second))
+			}
+			fmt.Fprintf(w, "standby %s from %s: last request %s, last synced %s\n", st.Name, st.RemoteIP, st.LastRequest.Format(time.RFC3339), synced)
+		}
+		if len(l) == 0 {
+			fmt.Fprintln(w, "no standbys seen")
+		}
+		w.xclose()
+
 	case "backup":
 		backupctl(ctx, ctl)
 
@@ -1633,3 +2266,29 @@ func servectlcmd(ctx context.Context, ctl *ctl, shutdown func()) {
 		return
 	}
 }
+
+// messageEventLine formats a message event for "mox message trace".
+func messageEventLine(e admindb.MessageEvent) string {
+	s := fmt.Sprintf("%s %s", e.Time.Format(time.RFC3339), e.Kind)
+	add := func(k, v string) {
+		if v != "" {
+			s += fmt.Sprintf(" %s:%s", k, v)
+		}
+	}
+	if e.QueueID != 0 {
+		add("queueid", fmt.Sprintf("%d", e.QueueID))
+	}
+	add("account", e.Account)
+	add("rcpt", e.Recipient)
+	add("remote", e.Remote)
+	if e.Cid != 0 {
+		add("cid", fmt.Sprintf("%x", e.Cid))
+	}
+	if e.Result != "" {
+		add("result", fmt.Sprintf("%q", e.Result))
+	}
+	if e.Detail != "" {
+		add("detail", fmt.Sprintf("%q", e.Detail))
+	}
+	return s
+}
@@ -3,15 +3,26 @@
 package main
 
 import (
+	"archive/tar"
+	"bytes"
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/adminapisrv"
+	"github.com/mjl-/mox/admindb"
 	"github.com/mjl-/mox/config"
 	"github.com/mjl-/mox/dmarcdb"
 	"github.com/mjl-/mox/dns"
@@ -55,10 +66,17 @@ func TestCtl(t *testing.T) {
 		cconn, sconn := net.Pipe()
 		clientctl := ctl{conn: cconn, log: pkglog}
 		serverctl := ctl{conn: sconn, log: pkglog}
-		go servectlcmd(ctxbg, &serverctl, func() {})
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			servectlcmd(ctxbg, &serverctl, func() {})
+		}()
 		fn(&clientctl)
 		cconn.Close()
 		sconn.Close()
+		// Wait for server to finish, it may still be closing an account, which checks
+		// consistency that the next test may be deliberately breaking.
+		<-done
 	}
 
 	// "deliver"
@@ -246,12 +264,20 @@ func TestCtl(t *testing.T) {
 
 	// "importmbox"
 	testctl(func(ctl *ctl) {
-		ctlcmdImport(ctl, true, "mjl", "inbox", "testdata/importtest.mbox")
+		ctlcmdImport(ctl, "mbox", "mjl", "inbox", "testdata/importtest.mbox")
 	})
 
 	// "importmaildir"
 	testctl(func(ctl *ctl) {
-		ctlcmdImport(ctl, false, "mjl", "inbox", "testdata/importtest.maildir")
+		ctlcmdImport(ctl, "maildir", "mjl", "inbox", "testdata/importtest.maildir")
+	})
+
+	// "importpst"
+	testctl(func(ctl *ctl) {
+		ctlcmdImport(ctl, "pst", "mjl", "", "testdata/importtest.pst")
+	})
+	testctl(func(ctl *ctl) {
+		ctlcmdImport(ctl, "pst", "mjl", "Archive", "testdata/importtest.pst")
 	})
 
 	// "domainadd"
@@ -278,6 +304,11 @@ func TestCtl(t *testing.T) {
 		ctlcmdRetrain(ctl, "mjl2")
 	})
 
+	// "retrainbacklog"
+	testctl(func(ctl *ctl) {
+		ctlcmdRetrainBacklog(ctl, "mjl2")
+	})
+
 	// "addressrm"
 	testctl(func(ctl *ctl) {
 		ctlcmdConfigAddressRemove(ctl, "mjl3@mox2.example")
@@ -298,6 +329,14 @@ func TestCtl(t *testing.T) {
 		ctlcmdConfigAliasAdd(ctl, "support@mox.example", config.Alias{Addresses: []string{"mjl@mox.example"}})
 	})
 
+	// "addresslist"
+	testctl(func(ctl *ctl) {
+		ctlcmdConfigAddresses(ctl, nil)
+	})
+	testctl(func(ctl *ctl) {
+		ctlcmdConfigAddresses(ctl, []string{"mox.example"})
+	})
+
 	// "aliaslist"
 	testctl(func(ctl *ctl) {
 		ctlcmdConfigAliasList(ctl, "mox.example")
@@ -328,6 +367,163 @@ func TestCtl(t *testing.T) {
 		ctlcmdConfigAliasRemove(ctl, "support@mox.example")
 	})
 
+	// "apitokenadd"
+	testctl(func(ctl *ctl) {
+		ctlcmdConfigAPITokenAdd(ctl, "provisioning", "domains")
+	})
+
+	// "apitokenlist"
+	testctl(func(ctl *ctl) {
+		ctlcmdConfigAPITokenList(ctl)
+	})
+
+	// "apitokenrm"
+	testctl(func(ctl *ctl) {
+		ctlcmdConfigAPITokenRemove(ctl, "provisioning")
+	})
+
+	// Changes are in the audit log, without passwords.
+	auditEntries, err := admindb.AuditList(ctxbg, admindb.AuditFilter{Source: "ctl"})
+	tcheck(t, err, "audit list")
+	if len(auditEntries) == 0 || auditEntries[0].Action != "apitokenrm" || auditEntries[0].Params != `["provisioning"]` {
+		t.Fatalf("unexpected audit log entries %#v", auditEntries)
+	}
+	for _, e := range auditEntries {
+		if e.Action == "setaccountpassword" && e.Params != `["mjl","***"]` {
+			t.Fatalf("password not redacted in audit log: %s", e.Params)
+		}
+		if e.Action == "aliaslist" || e.Action == "apitokenlist" {
+			t.Fatalf("read-only command %s in audit log", e.Action)
+		}
+	}
+
+	// "healthcheck"
+	testctl(func(ctl *ctl) {
+		ctlcmdHealthcheck(ctl, true)
+	})
+
+	// "reload"
+	testctl(func(ctl *ctl) {
+		ctlcmdAdminReload(ctl, true)
+	})
+	testctl(func(ctl *ctl) {
+		ctlcmdAdminReload(ctl, false)
+	})
+
+	// "configapply"
+	domainsConf, err := os.ReadFile(mox.ConfigDynamicPath)
+	tcheck(t, err, "reading domains.conf")
+	testctl(func(ctl *ctl) {
+		ctlcmdConfigApply(ctl, domainsConf, true)
+	})
+	testctl(func(ctl *ctl) {
+		ctlcmdConfigApply(ctl, domainsConf, false)
+	})
+
+	// "messagetrace"
+	admindb.MessageEventAdd(ctxbg, pkglog, admindb.MessageEvent{MessageID: "<test@mox.example>", Kind: admindb.EventReceived})
+	testctl(func(ctl *ctl) {
+		ctlcmdMessageTrace(ctl, "test@mox.example")
+	})
+	testctl(func(ctl *ctl) {
+		ctlcmdMessageTrace(ctl, "unknown@mox.example")
+	})
+	tm := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	if line := messageEventLine(admindb.MessageEvent{Time: tm, Kind: admindb.EventReceived}); line != "2026-10-16T12:00:00Z received" {
+		t.Fatalf("got message event line %q", line)
+	}
+	line := messageEventLine(admindb.MessageEvent{Time: tm, Kind: admindb.EventAttempt, QueueID: 10, Account: "mjl", Recipient: "a@other.example", Remote: "mx.other.example 198.51.100.1", Cid: 0x1f, Result: "451 4.7.0", Detail: "try again later"})
+	if exp := `2026-10-16T12:00:00Z attempt queueid:10 account:mjl rcpt:a@other.example remote:mx.other.example 198.51.100.1 cid:1f result:"451 4.7.0" detail:"try again later"`; line != exp {
+		t.Fatalf("got message event line %q, expected %q", line, exp)
+	}
+
+	// "usage"
+	err = admindb.UsageAdd(ctxbg, time.Now(), "mjl", "mox.example", admindb.Usage{MessagesReceived: 1, BytesReceived: 100})
+	tcheck(t, err, "adding usage")
+	for _, format := range []string{"text", "csv", "json"} {
+		testctl(func(ctl *ctl) {
+			ctlcmdUsage(ctl, admindb.UsageFilter{Account: "mjl"}, "domain", format)
+		})
+	}
+	var usageBuf strings.Builder
+	err = usageWrite(&usageBuf, []admindb.Usage{{Month: "2026-10", Account: "mjl", Domain: "mox.example", MessagesSent: 2, BytesSent: 300}}, "csv")
+	tcheck(t, err, "writing usage")
+	if exp := "month,account,domain,messages_received,bytes_received,messages_sent,bytes_sent,stored_bytes\n2026-10,mjl,mox.example,0,0,2,300,0\n"; usageBuf.String() != exp {
+		t.Fatalf("usage csv: got %q, expected %q", usageBuf.String(), exp)
+	}
+
+	// "dnsblmonitor"
+	for _, listed := range []bool{false, true, true} {
+		_, err := admindb.DNSBLRecord(ctxbg, "sbl.spamhaus.org", "198.51.100.1", listed, "", time.Now())
+		tcheck(t, err, "recording dnsbl status")
+	}
+	if l, err := admindb.DNSBLChangeList(ctxbg, 0); err != nil || len(l) != 2 || !l[0].Listed {
+		t.Fatalf("dnsbl changes, got %v %v, expected 2 with latest listed", l, err)
+	}
+	testctl(func(ctl *ctl) {
+		ctlcmdDNSBLMonitor(ctl, false, 0)
+	})
+
+	// "top"
+	testctl(func(ctl *ctl) {
+		ctl.xwrite("top")
+		ctl.xwrite("10")
+		ctl.xwrite("10")
+		ctl.xreadok()
+		var snapshot topSnapshot
+		err := json.Unmarshal([]byte(ctl.xread()), &snapshot)
+		tcheck(t, err, "parsing top snapshot")
+		screen := topRender(snapshot, 0, "", 24, 80)
+		if !strings.Contains(screen, "Queue, ") || !strings.Contains(screen, "Recent events:") {
+			t.Fatalf("unexpected top screen %q", screen)
+		}
+	})
+
+	// "checkreceive", with a reply like from an echo service delivered once the test
+	// message is queued.
+	go func() {
+		for i := 0; i < 100; i++ {
+			time.Sleep(10 * time.Millisecond)
+			l, err := queue.List(ctxbg, queue.Filter{To: "echo@remote.example"}, queue.Sort{})
+			if err != nil || len(l) == 0 {
+				continue
+			}
+			reply := fmt.Sprintf("Subject: Re: %s\r\n\r\nAuthentication-Results: remote.example; spf=pass smtp.mailfrom=mox.example; dkim=pass header.d=mox.example; dmarc=pass header.from=mox.example\r\n", l[0].Subject)
+			f, err := store.CreateMessageTemp(pkglog, "checkreceive-test")
+			tcheck(t, err, "temp file")
+			defer store.CloseRemoveTempFile(pkglog, f, "test reply")
+			_, err = f.Write([]byte(reply))
+			tcheck(t, err, "write reply")
+			acc, err := store.OpenAccount(pkglog, "mjl")
+			tcheck(t, err, "open account")
+			m := store.Message{Received: time.Now(), Size: int64(len(reply))}
+			acc.WithWLock(func() {
+				err = acc.DeliverMailbox(pkglog, "Inbox", &m, f)
+			})
+			tcheck(t, err, "deliver reply")
+			err = acc.Close()
+			tcheck(t, err, "close account")
+			return
+		}
+	}()
+	testctl(func(ctl *ctl) {
+		ctl.xwrite("checkreceive")
+		ctl.xwrite("mjl@mox.example")
+		ctl.xwrite("echo@remote.example")
+		ctl.xwrite("10s")
+		ctl.xreadok()
+		var b strings.Builder
+		ctl.xstreamto(&b)
+		if result := ctl.xread(); result != "pass" || !strings.Contains(b.String(), "dmarc      pass") {
+			t.Fatalf("checkreceive result %q, output:\n%s", result, b.String())
+		}
+	})
+	// Report format of echo services. Our own results in the header are ignored.
+	verdicts := checkreceiveVerdicts([]byte("Authentication-Results: mox.example; spf=fail\r\n\r\nSPF check:          pass\r\nDKIM check:         fail\r\n"))
+	if verdicts["spf"] != "pass" || verdicts["dkim"] != "fail" || verdicts["dmarc"] != "" {
+		t.Fatalf("unexpected verdicts %v", verdicts)
+	}
+
 	// "loglevels"
 	testctl(func(ctl *ctl) {
 		ctlcmdLoglevels(ctl)
@@ -342,13 +538,17 @@ func TestCtl(t *testing.T) {
 	})
 
 	// Export data, import it again
-	xcmdExport(true, false, []string{filepath.FromSlash("testdata/ctl/data/tmp/export/mbox/"), filepath.FromSlash("testdata/ctl/data/accounts/mjl")}, &cmd{log: pkglog})
-	xcmdExport(false, false, []string{filepath.FromSlash("testdata/ctl/data/tmp/export/maildir/"), filepath.FromSlash("testdata/ctl/data/accounts/mjl")}, &cmd{log: pkglog})
+	xcmdExport(store.ExportMbox, exportOptions{}, []string{filepath.FromSlash("testdata/ctl/data/tmp/export/mbox/"), filepath.FromSlash("testdata/ctl/data/accounts/mjl")}, &cmd{log: pkglog})
+	xcmdExport(store.ExportMaildir, exportOptions{}, []string{filepath.FromSlash("testdata/ctl/data/tmp/export/maildir/"), filepath.FromSlash("testdata/ctl/data/accounts/mjl")}, &cmd{log: pkglog})
+	xcmdExport(store.ExportEML, exportOptions{}, []string{filepath.FromSlash("testdata/ctl/data/tmp/export/eml/"), filepath.FromSlash("testdata/ctl/data/accounts/mjl")}, &cmd{log: pkglog})
+	testctl(func(ctl *ctl) {
+		ctlcmdImport(ctl, "mbox", "mjl", "inbox", filepath.FromSlash("testdata/ctl/data/tmp/export/mbox/Inbox.mbox"))
+	})
 	testctl(func(ctl *ctl) {
-		ctlcmdImport(ctl, true, "mjl", "inbox", filepath.FromSlash("testdata/ctl/data/tmp/export/mbox/Inbox.mbox"))
+		ctlcmdImport(ctl, "maildir", "mjl", "inbox", filepath.FromSlash("testdata/ctl/data/tmp/export/maildir/Inbox"))
 	})
 	testctl(func(ctl *ctl) {
-		ctlcmdImport(ctl, false, "mjl", "inbox", filepath.FromSlash("testdata/ctl/data/tmp/export/maildir/Inbox"))
+		ctlcmdImport(ctl, "eml", "mjl", "inbox", filepath.FromSlash("testdata/ctl/data/tmp/export/eml/Inbox"))
 	})
 
 	// "recalculatemailboxcounts"
@@ -415,6 +615,14 @@ func TestCtl(t *testing.T) {
 		ctlcmdReparse(ctl, "")
 	})
 
+	// "fsck"
+	testctl(func(ctl *ctl) {
+		ctlcmdFsck(ctl, "mjl", true, []string{"orphan", "counts"})
+	})
+	testctl(func(ctl *ctl) {
+		ctlcmdFsck(ctl, "", false, nil)
+	})
+
 	// "reassignthreads"
 	testctl(func(ctl *ctl) {
 		ctlcmdReassignthreads(ctl, "mjl")
@@ -423,6 +631,40 @@ func TestCtl(t *testing.T) {
 		ctlcmdReassignthreads(ctl, "")
 	})
 
+	// "reindex"
+	testctl(func(ctl *ctl) {
+		ctlcmdReindex(ctl, "mjl", "parse,threads,counts", false)
+	})
+	testctl(func(ctl *ctl) {
+		ctlcmdReindex(ctl, "", "counts", true)
+	})
+
+	// "upgradeaccounts"
+	testctl(func(ctl *ctl) {
+		ctlcmdUpgradeaccounts(ctl, "")
+	})
+
+	// "compressmessages"
+	testctl(func(ctl *ctl) {
+		ctlcmdCompressmessages(ctl, "mjl")
+	})
+	testctl(func(ctl *ctl) {
+		ctlcmdCompressmessages(ctl, "")
+	})
+
+	// "replicationstatus"
+	testctl(func(ctl *ctl) {
+		ctlcmdReplicationStatus(ctl)
+	})
+
+	// "dedup"
+	testctl(func(ctl *ctl) {
+		ctlcmdDedup(ctl, "mjl")
+	})
+	testctl(func(ctl *ctl) {
+		ctlcmdDedup(ctl, "")
+	})
+
 	// "backup", backup account.
 	err = dmarcdb.Init()
 	tcheck(t, err, "dmarcdb init")
@@ -430,17 +672,284 @@ func TestCtl(t *testing.T) {
 	tcheck(t, err, "mtastsdb init")
 	err = tlsrptdb.Init()
 	tcheck(t, err, "tlsrptdb init")
+	err = admindb.Init()
+	tcheck(t, err, "admindb init")
 	testctl(func(ctl *ctl) {
 		os.RemoveAll("testdata/ctl/data/tmp/backup-data")
 		err := os.WriteFile("testdata/ctl/data/receivedid.key", make([]byte, 16), 0600)
 		tcheck(t, err, "writing receivedid.key")
-		ctlcmdBackup(ctl, filepath.FromSlash("testdata/ctl/data/tmp/backup-data"), false)
+		ctlcmdBackup(ctl, filepath.FromSlash("testdata/ctl/data/tmp/backup-data"), false, "", filepath.FromSlash("testdata/ctl/data/tmp/backup-manifest.json"))
+	})
+
+	// Incremental backup to directory.
+	testctl(func(ctl *ctl) {
+		os.RemoveAll("testdata/ctl/data/tmp/backup-data-incr")
+		ctlcmdBackup(ctl, filepath.FromSlash("testdata/ctl/data/tmp/backup-data-incr"), true, filepath.FromSlash("testdata/ctl/data/tmp/backup-data"), "")
+	})
+
+	// Streamed backups, full and incremental against the manifest of the full backup.
+	streamBackup := func(dst, previous, manifest string) {
+		testctl(func(ctl *ctl) {
+			f, err := os.Create(filepath.FromSlash(dst))
+			tcheck(t, err, "create backup file")
+			defer f.Close()
+			ctl.xwrite("backup")
+			ctl.xwrite("-")
+			ctl.xwrite("")
+			ctl.xwrite(filepath.FromSlash(previous))
+			ctl.xwrite(filepath.FromSlash(manifest))
+			ctl.xstreamto(f)
+			ctl.xreadok()
+		})
+	}
+	streamBackup("testdata/ctl/data/tmp/backup.tar", "", "testdata/ctl/data/tmp/backup-tar-manifest.json")
+	// Deliver a message, it must be in the incremental backup.
+	testctl(func(ctl *ctl) {
+		ctl.xwrite("deliver")
+		ctl.xwrite("mjl@mox.example")
+		ctl.xreadok()
+		ctl.xstreamfrom(strings.NewReader("Subject: incremental\r\n\r\ntest\r\n"))
+		if line := ctl.xread(); line != "ok" {
+			t.Fatalf("deliver: %s", line)
+		}
 	})
+	streamBackup("testdata/ctl/data/tmp/backup-incr.tar", "testdata/ctl/data/tmp/backup-tar-manifest.json", "")
+
+	// Verify the backups.
+	verifybackup := func(args ...string) {
+		xcmd := cmd{
+			flag:     flag.NewFlagSet("", flag.ExitOnError),
+			flagArgs: args,
+		}
+		cmdVerifybackup(&xcmd)
+	}
+	verifybackup("-deep", filepath.FromSlash("testdata/ctl/data/tmp/backup-data"))
+	verifybackup("-deep", filepath.FromSlash("testdata/ctl/data/tmp/backup-data-incr"))
+	verifybackup(filepath.FromSlash("testdata/ctl/data/tmp/backup.tar"))
+	verifybackup(filepath.FromSlash("testdata/ctl/data/tmp/backup-incr.tar"))
+
+	// Message files of the incremental backup directory are linked from the previous
+	// backup.
+	fullManifest, err := readBackupManifest(filepath.FromSlash("testdata/ctl/data/tmp/backup-data"))
+	tcheck(t, err, "read manifest of full backup")
+	incrManifest, err := readBackupManifest(filepath.FromSlash("testdata/ctl/data/tmp/backup-data-incr"))
+	tcheck(t, err, "read manifest of incremental backup")
+	if !incrManifest.Previous.Equal(fullManifest.Time) || !fullManifest.Previous.IsZero() {
+		t.Fatalf("incremental backup is against backup of %v, full backup at %v", incrManifest.Previous, fullManifest.Time)
+	}
+	isMessage := func(e backupEntry) bool {
+		return e.SHA256 == "" && e.Chunks == nil
+	}
+	var nlinked int
+	for _, e := range incrManifest.Files {
+		if !isMessage(e) {
+			continue
+		}
+		fi0, err := os.Stat(filepath.Join("testdata/ctl/data/tmp/backup-data", filepath.FromSlash(e.Path)))
+		tcheck(t, err, "stat message in full backup")
+		fi1, err := os.Stat(filepath.Join("testdata/ctl/data/tmp/backup-data-incr", filepath.FromSlash(e.Path)))
+		tcheck(t, err, "stat message in incremental backup")
+		if os.SameFile(fi0, fi1) {
+			nlinked++
+		}
+	}
+	if nlinked == 0 {
+		t.Fatalf("no message files linked from previous backup")
+	}
+
+	// The incremental stream only has the new message, and the changed chunks of
+	// databases.
+	tarManifest, err := readBackupManifest(filepath.FromSlash("testdata/ctl/data/tmp/backup-tar-manifest.json"))
+	tcheck(t, err, "read manifest of streamed full backup")
+	prevFiles := map[string]backupEntry{}
+	for _, e := range tarManifest.Files {
+		prevFiles[e.Path] = e
+	}
+	f, err := os.Open(filepath.FromSlash("testdata/ctl/data/tmp/backup-incr.tar"))
+	tcheck(t, err, "open incremental backup")
+	tarFiles := map[string]int64{}
+	var streamManifest backupManifest
+	tr := tar.NewReader(f)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		tcheck(t, err, "reading incremental backup")
+		if h.Name == backupManifestName {
+			err := json.NewDecoder(tr).Decode(&streamManifest)
+			tcheck(t, err, "parse manifest")
+		}
+		tarFiles[h.Name] = h.Size
+	}
+	f.Close()
+	var nomitted, nnew, ndelta int
+	for _, e := range streamManifest.Files {
+		prev, inPrev := prevFiles[e.Path]
+		switch {
+		case isMessage(e) && inPrev:
+			if !e.Omitted || tarFiles[e.Path] != 0 {
+				t.Fatalf("message file %s from previous backup not omitted", e.Path)
+			}
+			nomitted++
+		case isMessage(e):
+			if _, ok := tarFiles[e.Path]; !ok || e.Omitted {
+				t.Fatalf("new message file %s not in incremental backup", e.Path)
+			}
+			nnew++
+		case e.Chunks != nil && inPrev:
+			if !e.Delta || tarFiles[e.Path+".delta"] > e.Size || tarFiles[e.Path] != 0 {
+				t.Fatalf("database %s not stored as delta", e.Path)
+			}
+			for _, i := range e.DeltaChunks {
+				if i < len(prev.Chunks) && prev.Chunks[i] == e.Chunks[i] {
+					t.Fatalf("unchanged chunk %d of database %s in delta", i, e.Path)
+				}
+			}
+			if e.Path == "accounts/mjl/index.db" && len(e.DeltaChunks) == 0 {
+				t.Fatalf("no changed chunks for account database after delivery")
+			}
+			ndelta++
+		}
+	}
+	if nomitted == 0 || nnew != 1 || ndelta == 0 {
+		t.Fatalf("got %d omitted, %d new message files and %d deltas, expected >0, 1, >0", nomitted, nnew, ndelta)
+	}
 
-	// Verify the backup.
+	// Backup directory from streamed full and incremental backup.
+	os.RemoveAll("testdata/ctl/data/tmp/backup-extract")
 	xcmd := cmd{
+		flag:     flag.NewFlagSet("", flag.ExitOnError),
+		flagArgs: []string{filepath.FromSlash("testdata/ctl/data/tmp/backup-extract"), filepath.FromSlash("testdata/ctl/data/tmp/backup.tar"), filepath.FromSlash("testdata/ctl/data/tmp/backup-incr.tar")},
+	}
+	cmdExtractbackup(&xcmd)
+	verifybackup("-deep", filepath.FromSlash("testdata/ctl/data/tmp/backup-extract"))
+
+	// Deep check finds message files that don't match the account database. Files
+	// are replaced, not modified, they may be hardlinks.
+	extractManifest, err := readBackupManifest(filepath.FromSlash("testdata/ctl/data/tmp/backup-extract"))
+	tcheck(t, err, "read manifest of extracted backup")
+	var msgPath string
+	for _, e := range extractManifest.Files {
+		if strings.HasPrefix(e.Path, "accounts/mjl/msg/") {
+			msgPath = filepath.Join("testdata/ctl/data/tmp/backup-extract", filepath.FromSlash(e.Path))
+			break
+		}
+	}
+	msgBuf, err := os.ReadFile(msgPath)
+	tcheck(t, err, "read message file")
+	// The file may be stored compressed, so we only check the error is about the file.
+	verifyMessages := func(buf []byte, expErr bool) {
+		t.Helper()
+		err := os.Remove(msgPath)
+		tcheck(t, err, "remove message file")
+		err = os.WriteFile(msgPath, buf, 0660)
+		tcheck(t, err, "write message file")
+		var c backupChecker
+		verifyBackupMessages(pkglog, filepath.FromSlash("testdata/ctl/data/tmp/backup-extract"), c.checkf)
+		if !expErr && len(c.errs) > 0 || expErr && (len(c.errs) != 1 || !strings.HasPrefix(c.errs[0], msgPath+": ")) {
+			t.Fatalf("got errors %v, expected error for %s: %v", c.errs, msgPath, expErr)
+		}
+	}
+	verifyMessages(msgBuf[:len(msgBuf)-1], true)
+	verifyMessages(bytes.Repeat([]byte("x"), len(msgBuf)), true)
+	verifyMessages(msgBuf, false)
+
+	// "restore", twice, the second time all messages are already present.
+	countRestored := func() int {
+		acc, err := store.OpenAccount(pkglog, "mjl")
+		tcheck(t, err, "open account")
+		defer func() {
+			acc.Close()
+			acc.CheckClosed()
+		}()
+		mb, err := bstore.QueryDB[store.Mailbox](ctxbg, acc.DB).FilterNonzero(store.Mailbox{Name: "Restored/Inbox"}).Get()
+		tcheck(t, err, "get restored mailbox")
+		n, err := bstore.QueryDB[store.Message](ctxbg, acc.DB).FilterNonzero(store.Message{MailboxID: mb.ID}).FilterEqual("Expunged", false).Count()
+		tcheck(t, err, "count restored messages")
+		return n
+	}
+	testctl(func(ctl *ctl) {
+		ctlcmdRestore(ctl, filepath.FromSlash("testdata/ctl/data/tmp/backup-data"), "mjl", "", "Restored")
+	})
+	n := countRestored()
+	if n == 0 {
+		t.Fatalf("no messages restored")
+	}
+	testctl(func(ctl *ctl) {
+		ctlcmdRestore(ctl, filepath.FromSlash("testdata/ctl/data/tmp/backup-data"), "mjl", "Inbox", "Restored")
+	})
+	if xn := countRestored(); xn != n {
+		t.Fatalf("got %d restored messages after second restore, expected %d", xn, n)
+	}
+
+	xcmd = cmd{
 		flag:     flag.NewFlagSet("", flag.ExitOnError),
 		flagArgs: []string{filepath.FromSlash("testdata/ctl/data/tmp/backup-data")},
 	}
 	cmdVerifydata(&xcmd)
+
+	// "accounttakeout"
+	testctl(func(ctl *ctl) {
+		f, err := os.Create(filepath.FromSlash("testdata/ctl/data/tmp/takeout.tgz"))
+		tcheck(t, err, "create takeout file")
+		defer f.Close()
+		ctlcmdAccountTakeout(ctl, "mjl", f)
+	})
+
+	// "accountdeleterequest", "accountdeletecancel", "accountdeletelist", "accountdeletepurge"
+	testctl(func(ctl *ctl) {
+		ctlcmdConfigAccountAdd(ctl, "del", "del@mox.example")
+	})
+	testctl(func(ctl *ctl) {
+		ctlcmdAccountDeleteRequest(ctl, "del", time.Hour)
+	})
+	testctl(func(ctl *ctl) {
+		ctlcmdAccountDeleteList(ctl)
+	})
+	testctl(func(ctl *ctl) {
+		ctlcmdAccountDeleteCancel(ctl, "del")
+	})
+	testctl(func(ctl *ctl) {
+		ctlcmdAccountDeleteRequest(ctl, "del", 0)
+	})
+	testctl(func(ctl *ctl) {
+		ctlcmdAccountDeletePurge(ctl, "del")
+	})
+	if _, ok := mox.Conf.Account("del"); ok {
+		t.Fatalf("account still present after purge")
+	}
+
+	// Commands with -remote, through the admin API.
+	mox.LimitersInit()
+	token, _, err := admindb.TokenAdd(ctxbg, "remote", admindb.RoleAdmin)
+	tcheck(t, err, "add api token")
+	srv := httptest.NewServer(http.StripPrefix("/adminapi", adminapisrv.NewServer("/adminapi/", false)))
+	defer srv.Close()
+	remoteURL = srv.URL + "/adminapi/"
+	defer func() { remoteURL = "" }()
+	t.Setenv("MOXTOKEN", token)
+	rc := xremote()
+	remotecmdSetaccountpassword(rc, "mjl", "test4321")
+	remotecmdSetLoglevels(rc, "smtpserver", "debug")
+	remotecmdLoglevels(rc)
+	remotecmdSetLoglevels(rc, "smtpserver", "")
+	remotecmdQueueList(rc, queue.Filter{}, queue.Sort{})
+	remotecmdQueueHoldSet(rc, queue.Filter{Account: "mjl"}, true)
+	remotecmdQueueSchedule(rc, queue.Filter{Account: "mjl"}, true, 0)
+	remotecmdQueueDrop(rc, queue.Filter{IDs: []int64{-1}})
+	remotecmdConfigAccountAdd(rc, "remote", "remote@mox.example")
+	remotecmdConfigAddressAdd(rc, "remote2@mox.example", "remote")
+	remotecmdConfigAliasAdd(rc, "remotealias@mox.example", []string{"remote@mox.example"})
+	remotecmdConfigAliasUpdate(rc, "remotealias@mox.example", "true", "", "false")
+	remotecmdConfigAliasAddaddr(rc, "remotealias@mox.example", []string{"remote2@mox.example"})
+	remotecmdConfigAliasRmaddr(rc, "remotealias@mox.example", []string{"remote2@mox.example"})
+	remotecmdConfigAliasList(rc, "mox.example")
+	remotecmdConfigAliasPrint(rc, "remotealias@mox.example")
+	remotecmdConfigAliasRemove(rc, "remotealias@mox.example")
+	remotecmdConfigAddressRemove(rc, "remote2@mox.example")
+	remotecmdConfigAccountRemove(rc, "remote")
+	if _, ok := mox.Conf.Account("remote"); ok {
+		t.Fatalf("account still present after remote removal")
+	}
 }
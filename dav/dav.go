@@ -0,0 +1,756 @@
+// Package dav implements a CardDAV (RFC 6352) and CalDAV (RFC 4791) server, for
+// synchronizing the address book and calendar of an account with phones and
+// desktop clients.
+//
+// Only the parts of WebDAV (RFC 4918) needed by CardDAV and CalDAV clients are
+// implemented: OPTIONS, PROPFIND, REPORT (addressbook-multiget,
+// addressbook-query, calendar-multiget and calendar-query, without filtering),
+// GET, PUT and DELETE. Each account has a single address book and a single
+// calendar, there is no support for creating collections or locking.
+// Clients authenticate with HTTP basic authentication, with an email address of
+// the account and the account password.
+//
+// Layout of the URLs, relative to the configured path:
+//
+//	/ - principal, also the address book and calendar home set.
+//	/contacts/ - the address book.
+//	/contacts/<name>.vcf - a contact.
+//	/calendar/ - the calendar.
+//	/calendar/<name>.ics - a calendar event.
+package dav
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/store"
+	"github.com/mjl-/mox/webauth"
+)
+
+var pkglog = mlog.New("dav", nil)
+
+var metricResults = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "mox_dav_results_total",
+		Help: "HTTP DAV requests by method and result.",
+	},
+	[]string{"method", "result"}, // result: "badauth", "ok", or error code
+)
+
+// Namespaces of XML elements.
+const (
+	nsDAV     = "DAV:"
+	nsCardDAV = "urn:ietf:params:xml:ns:carddav"
+	nsCalDAV  = "urn:ietf:params:xml:ns:caldav"
+	nsCS      = "http://calendarserver.org/ns/"
+)
+
+// Maximum size of a request body.
+const maxRequestSize = 1024 * 1024
+
+type server struct {
+	path        string // Full path, ending with a slash, for hrefs in responses.
+	isForwarded bool
+}
+
+// NewServer returns a new http.Handler for CardDAV and CalDAV. Path is the full
+// path the handler is served on, ending with a slash, and used for hrefs in
+// responses. The handler must be wrapped in http.StripPrefix with the path
+// without trailing slash.
+func NewServer(path string, isForwarded bool) http.Handler {
+	return server{path, isForwarded}
+}
+
+// davError is returned by request handlers through panic, and turned into an
+// HTTP error response.
+type davError struct {
+	code int
+	msg  string
+}
+
+func xerrorf(code int, format string, args ...any) {
+	panic(davError{code, fmt.Sprintf(format, args...)})
+}
+
+func (s server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := pkglog.WithContext(ctx)
+
+	method := r.Method
+	switch method {
+	case "OPTIONS", "PROPFIND", "REPORT", "GET", "HEAD", "PUT", "DELETE":
+	default:
+		method = "(other)"
+	}
+
+	// OPTIONS may be requested without authentication, to discover support.
+	if r.Method == "OPTIONS" {
+		metricResults.WithLabelValues(method, "ok").Inc()
+		w.Header().Set("DAV", "1, 3, addressbook, calendar-access")
+		w.Header().Set("Allow", "OPTIONS, PROPFIND, REPORT, GET, HEAD, PUT, DELETE")
+		return
+	}
+
+	email, password, aok := r.BasicAuth()
+	if !aok {
+		metricResults.WithLabelValues(method, "badauth").Inc()
+		w.Header().Set("WWW-Authenticate", `Basic realm="mox dav"`)
+		http.Error(w, "401 - unauthorized - use http basic auth with email address as username", http.StatusUnauthorized)
+		return
+	}
+	log = log.With(slog.String("username", email))
+
+	t0 := time.Now()
+	remoteIP := webauth.RemoteIP(log, s.isForwarded, r)
+	if remoteIP == nil {
+		metricResults.WithLabelValues(method, "internal").Inc()
+		http.Error(w, "500 - internal server error - cannot find remote ip", http.StatusInternalServerError)
+		return
+	}
+	if !mox.LimiterFailedAuth.CanAdd(remoteIP, t0, 1) {
+		metrics.AuthenticationRatelimitedInc("dav")
+		log.Debug("refusing connection due to many auth failures", slog.Any("remoteip", remoteIP))
+		http.Error(w, "429 - too many auth attempts", http.StatusTooManyRequests)
+		return
+	}
+
+	authResult := "error"
+	defer func() {
+		metrics.AuthenticationInc("dav", "httpbasic", authResult)
+	}()
+	acc, err := store.OpenEmailAuth(log, email, password)
+	if err != nil {
+		mox.LimiterFailedAuth.Add(remoteIP, t0, 1)
+		mox.AutoBanFailure(log, remoteIP, mox.AutoBanAuth)
+		if errors.Is(err, mox.ErrDomainNotFound) || errors.Is(err, mox.ErrAddressNotFound) || errors.Is(err, store.ErrUnknownCredentials) {
+			log.Debug("bad http basic authentication credentials")
+			metricResults.WithLabelValues(method, "badauth").Inc()
+			authResult = "badcreds"
+			w.Header().Set("WWW-Authenticate", `Basic realm="mox dav"`)
+			http.Error(w, "401 - unauthorized - use http basic auth with email address as username", http.StatusUnauthorized)
+			return
+		}
+		metricResults.WithLabelValues(method, "servererror").Inc()
+		log.Errorx("open account", err)
+		http.Error(w, "500 - internal server error - verifying credentials", http.StatusInternalServerError)
+		return
+	}
+	authResult = "ok"
+	mox.LimiterFailedAuth.Reset(remoteIP, t0)
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	defer func() {
+		x := recover()
+		if x == nil {
+			return
+		}
+		if err, ok := x.(davError); ok {
+			metricResults.WithLabelValues(method, fmt.Sprintf("%d", err.code)).Inc()
+			log.Debug("dav request error", slog.Int("code", err.code), slog.String("msg", err.msg))
+			http.Error(w, fmt.Sprintf("%d - %s - %s", err.code, strings.ToLower(http.StatusText(err.code)), err.msg), err.code)
+			return
+		}
+		log.Error("unhandled panic in dav request", slog.Any("x", x))
+		debug.PrintStack()
+		metrics.PanicInc(metrics.Dav)
+		http.Error(w, "500 - internal server error", http.StatusInternalServerError)
+	}()
+
+	s.handle(ctx, log, acc, w, r)
+	metricResults.WithLabelValues(method, "ok").Inc()
+}
+
+// resource is the kind of resource a path refers to.
+type resource int
+
+const (
+	resHome     resource = iota // Principal, address book home and calendar home.
+	resContacts                 // Address book collection.
+	resContact                  // Single vCard.
+	resCalendar                 // Calendar collection.
+	resEvent                    // Single iCalendar object.
+)
+
+// parsePath returns the resource for the path, and the name of the contact or
+// event for resContact and resEvent.
+func parsePath(p string) (resource, string) {
+	switch {
+	case p == "/" || p == "":
+		return resHome, ""
+	case p == "/contacts/" || p == "/contacts":
+		return resContacts, ""
+	case strings.HasPrefix(p, "/contacts/") && !strings.Contains(p[len("/contacts/"):], "/"):
+		return resContact, p[len("/contacts/"):]
+	case p == "/calendar/" || p == "/calendar":
+		return resCalendar, ""
+	case strings.HasPrefix(p, "/calendar/") && !strings.Contains(p[len("/calendar/"):], "/"):
+		return resEvent, p[len("/calendar/"):]
+	}
+	xerrorf(http.StatusNotFound, "no such resource")
+	panic("not reached")
+}
+
+func (s server) handle(ctx context.Context, log mlog.Log, acc *store.Account, w http.ResponseWriter, r *http.Request) {
+	res, name := parsePath(r.URL.Path)
+
+	switch r.Method {
+	case "PROPFIND":
+		s.propfind(ctx, acc, w, r, res, name)
+
+	case "REPORT":
+		if res != resContacts && res != resCalendar {
+			xerrorf(http.StatusForbidden, "reports only supported on address book and calendar")
+		}
+		s.report(ctx, acc, w, r, res)
+
+	case "GET", "HEAD":
+		var buf []byte
+		var etag, ctype string
+		var updated time.Time
+		switch res {
+		case resContact:
+			c := xcontact(ctx, acc, name)
+			buf, etag, ctype, updated = c.CardData(), c.ETag(), "text/vcard; charset=utf-8", c.Updated
+		case resEvent:
+			e := xevent(ctx, acc, name)
+			buf, etag, ctype, updated = e.CalendarData(), e.ETag(), "text/calendar; charset=utf-8", e.Updated
+		default:
+			xerrorf(http.StatusMethodNotAllowed, "only contacts and events can be retrieved")
+		}
+		h := w.Header()
+		h.Set("Content-Type", ctype)
+		h.Set("ETag", etag)
+		h.Set("Last-Modified", updated.UTC().Format(http.TimeFormat))
+		h.Set("Content-Length", fmt.Sprintf("%d", len(buf)))
+		if r.Method == "GET" {
+			_, err := w.Write(buf)
+			log.Check(err, "writing resource")
+		}
+
+	case "PUT":
+		if res != resContact && res != resEvent {
+			xerrorf(http.StatusMethodNotAllowed, "only contacts and events can be stored")
+		}
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestSize))
+		if err != nil {
+			xerrorf(http.StatusBadRequest, "reading request: %v", err)
+		}
+		var etag string
+		var created bool
+		if res == resContact {
+			var c store.Contact
+			c, created, err = acc.ContactPut(ctx, name, data, r.Header.Get("If-Match"), r.Header.Get("If-None-Match"))
+			etag = c.ETag()
+		} else {
+			var e store.CalendarEvent
+			e, created, err = acc.CalendarEventPut(ctx, name, data, r.Header.Get("If-Match"), r.Header.Get("If-None-Match"))
+			etag = e.ETag()
+		}
+		if errors.Is(err, store.ErrContactPrecondition) || errors.Is(err, store.ErrCalendarPrecondition) {
+			xerrorf(http.StatusPreconditionFailed, "%v", err)
+		} else if errors.Is(err, store.ErrContactParam) || errors.Is(err, store.ErrCalendarParam) {
+			xerrorf(http.StatusBadRequest, "%v", err)
+		} else if err != nil {
+			xerrorf(http.StatusInternalServerError, "storing resource: %v", err)
+		}
+		w.Header().Set("ETag", etag)
+		if created {
+			w.WriteHeader(http.StatusCreated)
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+
+	case "DELETE":
+		var etag string
+		var remove func() error
+		switch res {
+		case resContact:
+			c := xcontact(ctx, acc, name)
+			etag, remove = c.ETag(), func() error { return acc.ContactRemove(ctx, c.ID) }
+		case resEvent:
+			e := xevent(ctx, acc, name)
+			etag, remove = e.ETag(), func() error { return acc.CalendarEventRemove(ctx, e.ID) }
+		default:
+			xerrorf(http.StatusForbidden, "only contacts and events can be removed")
+		}
+		if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && ifMatch != etag {
+			xerrorf(http.StatusPreconditionFailed, "etag does not match")
+		}
+		if err := remove(); err != nil {
+			xerrorf(http.StatusInternalServerError, "removing resource: %v", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		xerrorf(http.StatusMethodNotAllowed, "method not supported")
+	}
+}
+
+// xcontact returns the contact with name, or fails with 404.
+func xcontact(ctx context.Context, acc *store.Account, name string) store.Contact {
+	l, err := acc.Contacts(ctx)
+	if err != nil {
+		xerrorf(http.StatusInternalServerError, "listing contacts: %v", err)
+	}
+	for _, c := range l {
+		if c.Href == name {
+			return c
+		}
+	}
+	xerrorf(http.StatusNotFound, "no such contact")
+	panic("not reached")
+}
+
+// xevent returns the calendar event with name, or fails with 404.
+func xevent(ctx context.Context, acc *store.Account, name string) store.CalendarEvent {
+	l, err := acc.CalendarEvents(ctx)
+	if err != nil {
+		xerrorf(http.StatusInternalServerError, "listing calendar events: %v", err)
+	}
+	for _, e := range l {
+		if e.Href == name {
+			return e
+		}
+	}
+	xerrorf(http.StatusNotFound, "no such event")
+	panic("not reached")
+}
+
+// propName is the name of a requested property.
+type propName struct {
+	XMLName xml.Name
+}
+
+// propList is the list of requested properties, in a "prop" element.
+type propList struct {
+	Names []propName `xml:",any"`
+}
+
+type propfindRequest struct {
+	XMLName  xml.Name  `xml:"DAV: propfind"`
+	AllProp  *struct{} `xml:"DAV: allprop"`
+	PropName *struct{} `xml:"DAV: propname"`
+	Prop     propList  `xml:"DAV: prop"`
+}
+
+type reportRequest struct {
+	XMLName xml.Name
+	Prop    propList `xml:"DAV: prop"`
+	Hrefs   []string `xml:"DAV: href"`
+}
+
+// xreadXML parses the request body into v. If the body is empty, false is
+// returned.
+func xreadXML(w http.ResponseWriter, r *http.Request, v any) bool {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	if err != nil {
+		xerrorf(http.StatusBadRequest, "reading request: %v", err)
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return false
+	}
+	if err := xml.Unmarshal(data, v); err != nil {
+		xerrorf(http.StatusBadRequest, "parsing xml request: %v", err)
+	}
+	return true
+}
+
+// prop is a known property of a resource, with a function writing its value as
+// XML.
+type prop struct {
+	name  xml.Name
+	value func(b *bytes.Buffer)
+}
+
+func text(s string) func(b *bytes.Buffer) {
+	return func(b *bytes.Buffer) {
+		xml.EscapeText(b, []byte(s))
+	}
+}
+
+func href(s string) func(b *bytes.Buffer) {
+	return func(b *bytes.Buffer) {
+		b.WriteString("<d:href>")
+		xml.EscapeText(b, []byte(s))
+		b.WriteString("</d:href>")
+	}
+}
+
+func raw(s string) func(b *bytes.Buffer) {
+	return func(b *bytes.Buffer) {
+		b.WriteString(s)
+	}
+}
+
+func dav(local string) xml.Name {
+	return xml.Name{Space: nsDAV, Local: local}
+}
+
+func carddav(local string) xml.Name {
+	return xml.Name{Space: nsCardDAV, Local: local}
+}
+
+func caldav(local string) xml.Name {
+	return xml.Name{Space: nsCalDAV, Local: local}
+}
+
+// commonProps returns properties available on all resources.
+func (s server) commonProps() []prop {
+	return []prop{
+		{dav("current-user-principal"), href(s.path)},
+		{dav("principal-URL"), href(s.path)},
+		{carddav("addressbook-home-set"), href(s.path)},
+		{caldav("calendar-home-set"), href(s.path)},
+	}
+}
+
+func (s server) homeProps() []prop {
+	return append(s.commonProps(),
+		prop{dav("resourcetype"), raw("<d:collection/><d:principal/>")},
+		prop{dav("displayname"), text("mox")},
+		prop{dav("current-user-privilege-set"), raw("<d:privilege><d:read/></d:privilege>")},
+	)
+}
+
+func (s server) contactsProps(ctx context.Context, acc *store.Account) []prop {
+	l, err := acc.Contacts(ctx)
+	if err != nil {
+		xerrorf(http.StatusInternalServerError, "listing contacts: %v", err)
+	}
+	// The ctag changes when a contact is added, changed or removed.
+	var last time.Time
+	for _, c := range l {
+		if c.Updated.After(last) {
+			last = c.Updated
+		}
+	}
+	ctag := fmt.Sprintf("%x-%x", len(l), last.UnixNano())
+
+	return append(s.commonProps(),
+		prop{dav("resourcetype"), raw("<d:collection/><card:addressbook/>")},
+		prop{dav("displayname"), text("Contacts")},
+		prop{xml.Name{Space: nsCS, Local: "getctag"}, text(ctag)},
+		prop{dav("getetag"), text(`"` + ctag + `"`)},
+		prop{carddav("supported-address-data"), raw(`<card:address-data-type content-type="text/vcard" version="3.0"/>`)},
+		prop{dav("supported-report-set"), raw("<d:supported-report><d:report><card:addressbook-multiget/></d:report></d:supported-report><d:supported-report><d:report><card:addressbook-query/></d:report></d:supported-report>")},
+		prop{dav("current-user-privilege-set"), raw("<d:privilege><d:read/></d:privilege><d:privilege><d:write/></d:privilege><d:privilege><d:write-content/></d:privilege><d:privilege><d:bind/></d:privilege><d:privilege><d:unbind/></d:privilege>")},
+	)
+}
+
+func (s server) calendarProps(ctx context.Context, acc *store.Account) []prop {
+	l, err := acc.CalendarEvents(ctx)
+	if err != nil {
+		xerrorf(http.StatusInternalServerError, "listing calendar events: %v", err)
+	}
+	// The ctag changes when an event is added, changed or removed.
+	var last time.Time
+	for _, e := range l {
+		if e.Updated.After(last) {
+			last = e.Updated
+		}
+	}
+	ctag := fmt.Sprintf("%x-%x", len(l), last.UnixNano())
+
+	return append(s.commonProps(),
+		prop{dav("resourcetype"), raw("<d:collection/><cal:calendar/>")},
+		prop{dav("displayname"), text("Calendar")},
+		prop{xml.Name{Space: nsCS, Local: "getctag"}, text(ctag)},
+		prop{dav("getetag"), text(`"` + ctag + `"`)},
+		prop{caldav("supported-calendar-component-set"), raw(`<cal:comp name="VEVENT"/>`)},
+		prop{caldav("supported-calendar-data"), raw(`<cal:calendar-data content-type="text/calendar" version="2.0"/>`)},
+		prop{dav("supported-report-set"), raw("<d:supported-report><d:report><cal:calendar-multiget/></d:report></d:supported-report><d:supported-report><d:report><cal:calendar-query/></d:report></d:supported-report>")},
+		prop{dav("current-user-privilege-set"), raw("<d:privilege><d:read/></d:privilege><d:privilege><d:write/></d:privilege><d:privilege><d:write-content/></d:privilege><d:privilege><d:bind/></d:privilege><d:privilege><d:unbind/></d:privilege>")},
+	)
+}
+
+// contactProps returns the properties of a contact. The address-data property is
+// only included if withData is set, it is not returned for "allprop".
+func (s server) contactProps(c store.Contact, withData bool) []prop {
+	l := append(s.commonProps(),
+		prop{dav("resourcetype"), raw("")},
+		prop{dav("getetag"), text(c.ETag())},
+		prop{dav("getcontenttype"), text("text/vcard; charset=utf-8")},
+		prop{dav("getlastmodified"), text(c.Updated.UTC().Format(http.TimeFormat))},
+	)
+	if withData {
+		l = append(l, prop{carddav("address-data"), text(string(c.CardData()))})
+	}
+	return l
+}
+
+// contactHref returns the full path of a contact.
+func (s server) contactHref(c store.Contact) string {
+	return s.path + "contacts/" + url.PathEscape(c.Href)
+}
+
+// eventProps returns the properties of a calendar event. The calendar-data
+// property is only included if withData is set.
+func (s server) eventProps(e store.CalendarEvent, withData bool) []prop {
+	l := append(s.commonProps(),
+		prop{dav("resourcetype"), raw("")},
+		prop{dav("getetag"), text(e.ETag())},
+		prop{dav("getcontenttype"), text("text/calendar; charset=utf-8; component=vevent")},
+		prop{dav("getlastmodified"), text(e.Updated.UTC().Format(http.TimeFormat))},
+	)
+	if withData {
+		l = append(l, prop{caldav("calendar-data"), text(string(e.CalendarData()))})
+	}
+	return l
+}
+
+// eventHref returns the full path of a calendar event.
+func (s server) eventHref(e store.CalendarEvent) string {
+	return s.path + "calendar/" + url.PathEscape(e.Href)
+}
+
+// writeElem writes the start or end tag of an element.
+func writeElem(b *bytes.Buffer, name xml.Name, end bool) {
+	b.WriteString("<")
+	if end {
+		b.WriteString("/")
+	}
+	switch name.Space {
+	case nsDAV:
+		b.WriteString("d:" + name.Local)
+	case nsCardDAV:
+		b.WriteString("card:" + name.Local)
+	case nsCalDAV:
+		b.WriteString("cal:" + name.Local)
+	case nsCS:
+		b.WriteString("cs:" + name.Local)
+	default:
+		b.WriteString(name.Local)
+		if !end && name.Space != "" {
+			b.WriteString(` xmlns="`)
+			xml.EscapeText(b, []byte(name.Space))
+			b.WriteString(`"`)
+		}
+	}
+	b.WriteString(">")
+}
+
+// writeResponse writes a response element for a multistatus. If names is nil,
+// all props are written. If nameOnly is set, only property names are written,
+// without values. Requested properties that are not known are returned with status
+// 404.
+func writeResponse(b *bytes.Buffer, hrefPath string, props []prop, names []xml.Name, nameOnly bool) {
+	b.WriteString("<d:response>")
+	href(hrefPath)(b)
+
+	var found []prop
+	var missing []xml.Name
+	if names == nil {
+		found = props
+	} else {
+	Names:
+		for _, n := range names {
+			for _, p := range props {
+				if p.name == n {
+					found = append(found, p)
+					continue Names
+				}
+			}
+			missing = append(missing, n)
+		}
+	}
+
+	if len(found) > 0 || len(missing) == 0 {
+		b.WriteString("<d:propstat><d:prop>")
+		for _, p := range found {
+			writeElem(b, p.name, false)
+			if !nameOnly {
+				p.value(b)
+			}
+			writeElem(b, p.name, true)
+		}
+		b.WriteString("</d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat>")
+	}
+	if len(missing) > 0 {
+		b.WriteString("<d:propstat><d:prop>")
+		for _, n := range missing {
+			writeElem(b, n, false)
+			writeElem(b, n, true)
+		}
+		b.WriteString("</d:prop><d:status>HTTP/1.1 404 Not Found</d:status></d:propstat>")
+	}
+	b.WriteString("</d:response>")
+}
+
+// writeNotFound writes a response element for an unknown resource.
+func writeNotFound(b *bytes.Buffer, hrefPath string) {
+	b.WriteString("<d:response>")
+	href(hrefPath)(b)
+	b.WriteString("<d:status>HTTP/1.1 404 Not Found</d:status></d:response>")
+}
+
+func newMultistatus() *bytes.Buffer {
+	b := &bytes.Buffer{}
+	b.WriteString(xml.Header)
+	b.WriteString(`<d:multistatus xmlns:d="DAV:" xmlns:card="` + nsCardDAV + `" xmlns:cal="` + nsCalDAV + `" xmlns:cs="` + nsCS + `">`)
+	return b
+}
+
+func writeMultistatus(w http.ResponseWriter, b *bytes.Buffer) {
+	b.WriteString("</d:multistatus>")
+	h := w.Header()
+	h.Set("Content-Type", "application/xml; charset=utf-8")
+	h.Set("Content-Length", fmt.Sprintf("%d", b.Len()))
+	w.WriteHeader(http.StatusMultiStatus)
+	_, err := w.Write(b.Bytes())
+	pkglog.Check(err, "writing multistatus response")
+}
+
+func (s server) propfind(ctx context.Context, acc *store.Account, w http.ResponseWriter, r *http.Request, res resource, name string) {
+	var req propfindRequest
+	var names []xml.Name
+	var nameOnly bool
+	if xreadXML(w, r, &req) && req.AllProp == nil {
+		nameOnly = req.PropName != nil
+		if !nameOnly {
+			names = []xml.Name{}
+			for _, p := range req.Prop.Names {
+				names = append(names, p.XMLName)
+			}
+		}
+	}
+
+	// Depth "infinity" is treated as 1, there are no deeper levels.
+	depth := r.Header.Get("Depth")
+	if depth != "0" && depth != "1" && depth != "" && depth != "infinity" {
+		xerrorf(http.StatusBadRequest, "bad depth header")
+	}
+	deep := depth != "0"
+
+	b := newMultistatus()
+	switch res {
+	case resHome:
+		writeResponse(b, s.path, s.homeProps(), names, nameOnly)
+		if deep {
+			writeResponse(b, s.path+"contacts/", s.contactsProps(ctx, acc), names, nameOnly)
+			writeResponse(b, s.path+"calendar/", s.calendarProps(ctx, acc), names, nameOnly)
+		}
+	case resContacts:
+		writeResponse(b, s.path+"contacts/", s.contactsProps(ctx, acc), names, nameOnly)
+		if deep {
+			l, err := acc.Contacts(ctx)
+			if err != nil {
+				xerrorf(http.StatusInternalServerError, "listing contacts: %v", err)
+			}
+			for _, c := range l {
+				writeResponse(b, s.contactHref(c), s.contactProps(c, false), names, nameOnly)
+			}
+		}
+	case resContact:
+		c := xcontact(ctx, acc, name)
+		writeResponse(b, s.contactHref(c), s.contactProps(c, names != nil), names, nameOnly)
+	case resCalendar:
+		writeResponse(b, s.path+"calendar/", s.calendarProps(ctx, acc), names, nameOnly)
+		if deep {
+			l, err := acc.CalendarEvents(ctx)
+			if err != nil {
+				xerrorf(http.StatusInternalServerError, "listing calendar events: %v", err)
+			}
+			for _, e := range l {
+				writeResponse(b, s.eventHref(e), s.eventProps(e, false), names, nameOnly)
+			}
+		}
+	case resEvent:
+		e := xevent(ctx, acc, name)
+		writeResponse(b, s.eventHref(e), s.eventProps(e, names != nil), names, nameOnly)
+	}
+	writeMultistatus(w, b)
+}
+
+// report handles the addressbook-multiget, addressbook-query, calendar-multiget
+// and calendar-query reports. Filters in the query reports are not implemented,
+// all contacts or events are returned.
+func (s server) report(ctx context.Context, acc *store.Account, w http.ResponseWriter, r *http.Request, res resource) {
+	var req reportRequest
+	if !xreadXML(w, r, &req) {
+		xerrorf(http.StatusBadRequest, "missing report request")
+	}
+	names := []xml.Name{}
+	for _, p := range req.Prop.Names {
+		names = append(names, p.XMLName)
+	}
+	if len(names) == 0 {
+		names = nil
+	}
+
+	// Collect the resources in the collection, with their names, full paths and properties.
+	type item struct {
+		name  string
+		href  string
+		props []prop
+	}
+	var items []item
+	var multiget, query xml.Name
+	var collection string
+	if res == resContacts {
+		multiget, query, collection = carddav("addressbook-multiget"), carddav("addressbook-query"), "contacts/"
+		l, err := acc.Contacts(ctx)
+		if err != nil {
+			xerrorf(http.StatusInternalServerError, "listing contacts: %v", err)
+		}
+		for _, c := range l {
+			items = append(items, item{c.Href, s.contactHref(c), s.contactProps(c, true)})
+		}
+	} else {
+		multiget, query, collection = caldav("calendar-multiget"), caldav("calendar-query"), "calendar/"
+		l, err := acc.CalendarEvents(ctx)
+		if err != nil {
+			xerrorf(http.StatusInternalServerError, "listing calendar events: %v", err)
+		}
+		for _, e := range l {
+			items = append(items, item{e.Href, s.eventHref(e), s.eventProps(e, true)})
+		}
+	}
+
+	b := newMultistatus()
+	switch req.XMLName {
+	case multiget:
+	Hrefs:
+		for _, h := range req.Hrefs {
+			h = strings.TrimSpace(h)
+			if u, err := url.Parse(h); err == nil {
+				h = u.Path
+			}
+			if strings.HasPrefix(h, s.path+collection) {
+				hname := h[len(s.path+collection):]
+				for _, it := range items {
+					if it.name == hname {
+						writeResponse(b, it.href, it.props, names, false)
+						continue Hrefs
+					}
+				}
+			}
+			writeNotFound(b, h)
+		}
+	case query:
+		for _, it := range items {
+			writeResponse(b, it.href, it.props, names, false)
+		}
+	default:
+		xerrorf(http.StatusForbidden, "unsupported report %s %s", req.XMLName.Space, req.XMLName.Local)
+	}
+	writeMultistatus(w, b)
+}
@@ -0,0 +1,168 @@
+package dav
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/store"
+)
+
+var ctxbg = context.Background()
+
+func tcheck(t *testing.T, err error, msg string) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %s", msg, err)
+	}
+}
+
+func tcompare(t *testing.T, got, expect any) {
+	t.Helper()
+	if !reflect.DeepEqual(got, expect) {
+		t.Fatalf("got:\n%#v\nexpected:\n%#v", got, expect)
+	}
+}
+
+func TestServer(t *testing.T) {
+	mox.LimitersInit()
+	os.RemoveAll("../testdata/dav/data")
+	mox.Context = ctxbg
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/dav/mox.conf")
+	mox.MustLoadConfig(true, false)
+	defer store.Switchboard()()
+
+	log := mlog.New("dav", nil)
+	acc, err := store.OpenAccount(log, "mjl")
+	tcheck(t, err, "open account")
+	err = acc.SetPassword(log, "test1234")
+	tcheck(t, err, "set password")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+		acc.CheckClosed()
+	}()
+
+	c, err := acc.ContactSave(ctxbg, store.Contact{Name: "Mox Test", Emails: []string{"test@mox.example"}})
+	tcheck(t, err, "save contact")
+
+	srv := http.StripPrefix("/dav", NewServer("/dav/", false))
+
+	// do makes a request and checks the status code. The response body is returned.
+	do := func(method, path, auth, body string, headers map[string]string, expCode int) (*http.Response, string) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if auth != "" {
+			user, pass, _ := strings.Cut(auth, ":")
+			req.SetBasicAuth(user, pass)
+		}
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		resp := rec.Result()
+		buf, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != expCode {
+			t.Fatalf("%s %s: got status %d, expected %d, body %q", method, path, resp.StatusCode, expCode, buf)
+		}
+		return resp, string(buf)
+	}
+	const auth = "mjl@mox.example:test1234"
+
+	// Discovery without authentication.
+	resp, _ := do("OPTIONS", "/dav/", "", "", nil, http.StatusOK)
+	tcompare(t, strings.Contains(resp.Header.Get("DAV"), "addressbook"), true)
+
+	do("PROPFIND", "/dav/", "", "", nil, http.StatusUnauthorized)
+	do("PROPFIND", "/dav/", "mjl@mox.example:bad", "", nil, http.StatusUnauthorized)
+	do("PROPFIND", "/dav/bogus/", auth, "", nil, http.StatusNotFound)
+
+	contains := func(body string, l ...string) {
+		t.Helper()
+		for _, s := range l {
+			if !strings.Contains(body, s) {
+				t.Fatalf("response %q does not contain %q", body, s)
+			}
+		}
+	}
+
+	// Principal, with an unknown property.
+	const propfindHome = `<?xml version="1.0"?><propfind xmlns="DAV:" xmlns:C="urn:ietf:params:xml:ns:carddav"><prop><current-user-principal/><C:addressbook-home-set/><x:unknown xmlns:x="urn:x"/></prop></propfind>`
+	_, body := do("PROPFIND", "/dav/", auth, propfindHome, map[string]string{"Depth": "0"}, http.StatusMultiStatus)
+	contains(body, "<d:current-user-principal><d:href>/dav/</d:href></d:current-user-principal>", "<card:addressbook-home-set><d:href>/dav/</d:href>", `<unknown xmlns="urn:x"></unknown>`, "404 Not Found")
+
+	// Address book and its contacts.
+	_, body = do("PROPFIND", "/dav/contacts/", auth, "", map[string]string{"Depth": "1"}, http.StatusMultiStatus)
+	href := "/dav/contacts/" + c.Href
+	contains(body, "<card:addressbook/>", "<cs:getctag>", "<d:href>"+href+"</d:href>", strings.ReplaceAll(c.ETag(), `"`, "&#34;"))
+
+	// Multiget and query.
+	multiget := fmt.Sprintf(`<C:addressbook-multiget xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:carddav"><D:prop><D:getetag/><C:address-data/></D:prop><D:href>%s</D:href><D:href>/dav/contacts/missing.vcf</D:href></C:addressbook-multiget>`, href)
+	_, body = do("REPORT", "/dav/contacts/", auth, multiget, nil, http.StatusMultiStatus)
+	contains(body, "FN:Mox Test", "EMAIL:test@mox.example", "<d:href>/dav/contacts/missing.vcf</d:href><d:status>HTTP/1.1 404 Not Found</d:status>")
+	_, body = do("REPORT", "/dav/contacts/", auth, `<C:addressbook-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:carddav"><D:prop><D:getetag/></D:prop></C:addressbook-query>`, nil, http.StatusMultiStatus)
+	contains(body, href)
+	do("REPORT", "/dav/contacts/", auth, `<D:sync-collection xmlns:D="DAV:"/>`, nil, http.StatusForbidden)
+
+	// Get.
+	resp, body = do("GET", href, auth, "", nil, http.StatusOK)
+	tcompare(t, resp.Header.Get("ETag"), c.ETag())
+	contains(body, "FN:Mox Test\r\n")
+	do("GET", "/dav/contacts/missing.vcf", auth, "", nil, http.StatusNotFound)
+
+	// Put, new and update with preconditions.
+	const vcf = "BEGIN:VCARD\r\nVERSION:3.0\r\nUID:new\r\nFN:New\r\nEMAIL:new@mox.example\r\nEND:VCARD\r\n"
+	resp, _ = do("PUT", "/dav/contacts/new.vcf", auth, vcf, map[string]string{"If-None-Match": "*"}, http.StatusCreated)
+	etag := resp.Header.Get("ETag")
+	do("PUT", "/dav/contacts/new.vcf", auth, vcf, map[string]string{"If-None-Match": "*"}, http.StatusPreconditionFailed)
+	do("PUT", "/dav/contacts/new.vcf", auth, vcf, map[string]string{"If-Match": `"bogus"`}, http.StatusPreconditionFailed)
+	do("PUT", "/dav/contacts/new.vcf", auth, "bogus", nil, http.StatusBadRequest)
+	resp, _ = do("PUT", "/dav/contacts/new.vcf", auth, strings.ReplaceAll(vcf, "FN:New", "FN:Newer"), map[string]string{"If-Match": etag}, http.StatusNoContent)
+	etag = resp.Header.Get("ETag")
+	l, err := acc.Contacts(ctxbg)
+	tcheck(t, err, "list contacts")
+	tcompare(t, len(l), 2)
+	tcompare(t, l[1].Name, "Newer")
+
+	// Delete.
+	do("DELETE", "/dav/contacts/new.vcf", auth, "", map[string]string{"If-Match": `"bogus"`}, http.StatusPreconditionFailed)
+	do("DELETE", "/dav/contacts/new.vcf", auth, "", map[string]string{"If-Match": etag}, http.StatusNoContent)
+	do("DELETE", "/dav/contacts/new.vcf", auth, "", nil, http.StatusNotFound)
+	do("DELETE", "/dav/contacts/", auth, "", nil, http.StatusForbidden)
+
+	// Calendar, empty at first.
+	resp, _ = do("OPTIONS", "/dav/calendar/", "", "", nil, http.StatusOK)
+	tcompare(t, strings.Contains(resp.Header.Get("DAV"), "calendar-access"), true)
+	_, body = do("PROPFIND", "/dav/", auth, `<propfind xmlns="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav"><prop><C:calendar-home-set/></prop></propfind>`, map[string]string{"Depth": "0"}, http.StatusMultiStatus)
+	contains(body, "<cal:calendar-home-set><d:href>/dav/</d:href>")
+	_, body = do("PROPFIND", "/dav/calendar/", auth, "", map[string]string{"Depth": "1"}, http.StatusMultiStatus)
+	contains(body, "<cal:calendar/>", `<cal:comp name="VEVENT"/>`)
+
+	// Put event, then get, query, multiget and delete.
+	const ics = "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//test//EN\r\nBEGIN:VEVENT\r\nUID:event1\r\nDTSTAMP:20240101T120000Z\r\nDTSTART:20240110T090000Z\r\nDTEND:20240110T100000Z\r\nSUMMARY:Planning\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+	resp, _ = do("PUT", "/dav/calendar/event1.ics", auth, ics, map[string]string{"If-None-Match": "*"}, http.StatusCreated)
+	etag = resp.Header.Get("ETag")
+	do("PUT", "/dav/calendar/event1.ics", auth, "bogus", nil, http.StatusBadRequest)
+	do("PUT", "/dav/calendar/event1.ics", auth, ics, map[string]string{"If-Match": `"bogus"`}, http.StatusPreconditionFailed)
+	resp, body = do("GET", "/dav/calendar/event1.ics", auth, "", nil, http.StatusOK)
+	tcompare(t, resp.Header.Get("ETag"), etag)
+	tcompare(t, resp.Header.Get("Content-Type"), "text/calendar; charset=utf-8")
+	contains(body, "SUMMARY:Planning\r\n")
+	_, body = do("REPORT", "/dav/calendar/", auth, `<C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav"><D:prop><D:getetag/><C:calendar-data/></D:prop></C:calendar-query>`, nil, http.StatusMultiStatus)
+	contains(body, "<d:href>/dav/calendar/event1.ics</d:href>", "<cal:calendar-data>BEGIN:VCALENDAR")
+	_, body = do("REPORT", "/dav/calendar/", auth, `<C:calendar-multiget xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav"><D:prop><D:getetag/></D:prop><D:href>/dav/calendar/event1.ics</D:href><D:href>/dav/calendar/missing.ics</D:href></C:calendar-multiget>`, nil, http.StatusMultiStatus)
+	contains(body, "<d:href>/dav/calendar/event1.ics</d:href><d:propstat>", "<d:href>/dav/calendar/missing.ics</d:href><d:status>HTTP/1.1 404 Not Found</d:status>")
+	do("REPORT", "/dav/calendar/", auth, multiget, nil, http.StatusForbidden)
+	do("DELETE", "/dav/calendar/event1.ics", auth, "", map[string]string{"If-Match": etag}, http.StatusNoContent)
+	do("GET", "/dav/calendar/event1.ics", auth, "", nil, http.StatusNotFound)
+}
@@ -1,3 +1,6 @@
+// Tests use a weak RSA key to check it is refused during verification.
+//go:debug rsa1024min=0
+
 package dkim
 
 import (
@@ -6,8 +6,9 @@
 // keeps track of the evaluations it does for incoming messages and sends reports
 // to mail servers that request reports.
 //
-// Only aggregate reports are stored and sent. Failure reports about individual
-// messages are not implemented.
+// Only aggregate reports are stored. Failure reports about individual messages
+// are only sent for policy domains configured in DMARCFailureReports, with the
+// original message redacted.
 package dmarcdb
 
 import (
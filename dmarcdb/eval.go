@@ -101,7 +101,7 @@ type Evaluation struct {
 	Addresses []string
 
 	// Policy used for evaluation. We don't store the "fo" field for failure reporting
-	// options, since we don't send failure reports for individual messages.
+	// options, failure reports are sent for individual messages during delivery.
 	PolicyPublished dmarcrpt.PolicyPublished
 
 	// For "row" in a report record.
@@ -0,0 +1,414 @@
+package dmarcdb
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/arf"
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/dmarc"
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/moxvar"
+	"github.com/mjl-/mox/publicsuffix"
+	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/store"
+)
+
+var metricFailureReport = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "mox_dmarcdb_failure_report_total",
+		Help: "DMARC failure reports for incoming messages, by result.",
+	},
+	[]string{"result"}, // queued, ratelimited, norecipient, error
+)
+
+// Header fields of the original message included in failure reports if none are
+// configured.
+var failureReportHeaders = []string{"From", "Sender", "Reply-To", "To", "Cc", "Subject", "Date", "Message-ID", "DKIM-Signature", "Authentication-Results", "Received-SPF"}
+
+// FailureReport is an incoming message that failed DMARC checks, to send a
+// failure report about.
+type FailureReport struct {
+	PolicyDomain dns.Domain    // Domain of the DMARC record.
+	Record       *dmarc.Record // With the failure reporting addresses.
+
+	// Fields for the message/feedback-report part. FeedbackType, UserAgent, Version
+	// and ReportingMTA are set by SendFailureReport.
+	Report arf.Report
+
+	// Redacted original message, as returned by FailureReportRedact.
+	Original []byte
+	HasBody  bool
+}
+
+// FailureReportDomain returns the failure report configuration for a DMARC
+// policy domain, matching configured parent domains too.
+func FailureReportDomain(policyDomain dns.Domain) (config.DMARCFailureReportsDomain, bool) {
+	fr := mox.Conf.Static.DMARCFailureReports
+	if fr == nil || mox.Conf.Static.NoOutgoingDMARCReports {
+		return config.DMARCFailureReportsDomain{}, false
+	}
+	d := policyDomain
+	var zerodom dns.Domain
+	for d != zerodom {
+		for _, dc := range fr.Domains {
+			if dc.Domain == d {
+				return dc, true
+			}
+		}
+		var nd dns.Domain
+		_, nd.ASCII, _ = strings.Cut(d.ASCII, ".")
+		_, nd.Unicode, _ = strings.Cut(d.Unicode, ".")
+		d = nd
+	}
+	return config.DMARCFailureReportsDomain{}, false
+}
+
+// FailureReportRedact returns the redacted original message for a failure
+// report: only the configured header fields, each truncated to the maximum header
+// size, and, if configured, the start of the body. Whether the body is included
+// is returned as well.
+func FailureReportRedact(dc config.DMARCFailureReportsDomain, msg io.Reader) (original []byte, hasBody bool, rerr error) {
+	headers := dc.Headers
+	if len(headers) == 0 {
+		headers = failureReportHeaders
+	}
+	maxHeaderSize := dc.MaxHeaderSize
+	if maxHeaderSize <= 0 {
+		maxHeaderSize = 1000
+	}
+
+	br := bufio.NewReader(msg)
+	hdrs, err := message.ReadHeaders(br)
+	if err != nil {
+		return nil, false, fmt.Errorf("reading message headers: %w", err)
+	}
+
+	// Split into header fields, each with its continuation lines.
+	var fields []string
+	for _, line := range strings.SplitAfter(string(hdrs), "\r\n") {
+		if line == "" || line == "\r\n" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1] += line
+		} else {
+			fields = append(fields, line)
+		}
+	}
+
+	var b []byte
+	for _, f := range fields {
+		k, _, ok := strings.Cut(f, ":")
+		if !ok || !slices.ContainsFunc(headers, func(h string) bool { return strings.EqualFold(h, strings.TrimSpace(k)) }) {
+			continue
+		}
+		if len(f) > maxHeaderSize {
+			n := maxHeaderSize
+			for n > 0 && !utf8.RuneStart(f[n]) {
+				n--
+			}
+			f = strings.TrimRight(f[:n], " \t\r\n") + "\r\n"
+		}
+		b = append(b, f...)
+	}
+
+	if dc.BodySize <= 0 {
+		return b, false, nil
+	}
+	body := make([]byte, dc.BodySize)
+	n, err := io.ReadFull(br, body)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, false, fmt.Errorf("reading message body: %w", err)
+	}
+	body = body[:n]
+	if n > 0 && !strings.HasSuffix(string(body), "\r\n") {
+		// Truncated in the middle of a line, drop the partial line.
+		if i := strings.LastIndex(string(body), "\r\n"); i >= 0 {
+			body = body[:i+2]
+		} else {
+			body = append(body, "\r\n"...)
+		}
+	}
+	b = append(b, "\r\n"...)
+	b = append(b, body...)
+	return b, true, nil
+}
+
+// Number of failure reports sent per policy domain in the current hour.
+var failureReportCounts = struct {
+	sync.Mutex
+	hour   time.Time
+	counts map[dns.Domain]int
+}{counts: map[dns.Domain]int{}}
+
+// failureReportAllowed returns whether another failure report can be sent to the
+// policy domain in the current hour, counting it if so.
+func failureReportAllowed(d dns.Domain, max int) bool {
+	if max <= 0 {
+		max = 10
+	}
+	failureReportCounts.Lock()
+	defer failureReportCounts.Unlock()
+	hour := time.Now().Truncate(time.Hour)
+	if !hour.Equal(failureReportCounts.hour) {
+		failureReportCounts.hour = hour
+		failureReportCounts.counts = map[dns.Domain]int{}
+	}
+	if failureReportCounts.counts[d] >= max {
+		return false
+	}
+	failureReportCounts.counts[d]++
+	return true
+}
+
+// SendFailureReport queues a failure report to the failure reporting addresses
+// (ruf) of the DMARC record, if failure reports are configured for the policy
+// domain and its hourly limit has not been reached.
+//
+// Addresses in another organizational domain must opt in to receiving reports
+// through a DNS record, like for aggregate reports. Addresses on the suppression
+// list and addresses with a maximum report size smaller than the report are
+// skipped.
+func SendFailureReport(ctx context.Context, log mlog.Log, resolver dns.Resolver, fr FailureReport) (rerr error) {
+	dc, ok := FailureReportDomain(fr.PolicyDomain)
+	if !ok || fr.Record == nil || len(fr.Record.FailureReportAddresses) == 0 {
+		return nil
+	}
+
+	defer func() {
+		if rerr != nil {
+			metricFailureReport.WithLabelValues("error").Inc()
+		}
+	}()
+
+	if !failureReportAllowed(dc.Domain, dc.MaxPerHour) {
+		log.Info("not sending dmarc failure report, hourly limit for domain reached", slog.Any("policydomain", fr.PolicyDomain))
+		metricFailureReport.WithLabelValues("ratelimited").Inc()
+		return nil
+	}
+
+	// Gather the recipients, following addresses in other organizational domains
+	// through their _report._dmarc record.
+	// ../rfc/7489:1556
+	var recipients []recipient
+	policyOrgDom := publicsuffix.Lookup(ctx, log.Logger, fr.PolicyDomain)
+	for _, uri := range fr.Record.FailureReportAddresses {
+		r, ok := parseRecipient(log, uri)
+		if !ok {
+			continue
+		}
+		if publicsuffix.Lookup(ctx, log.Logger, r.address.Domain) == policyOrgDom {
+			recipients = append(recipients, r)
+			continue
+		}
+
+		accepts, status, records, _, _, err := dmarc.LookupExternalReportsAccepted(ctx, log.Logger, resolver, policyOrgDom, r.address.Domain)
+		log.Debugx("checking if ruf address with different organization domain has opted into receiving dmarc reports", err,
+			slog.Any("policydomain", policyOrgDom),
+			slog.Any("destinationdomain", r.address.Domain),
+			slog.Bool("accepts", accepts),
+			slog.Any("status", status))
+		if !accepts {
+			continue
+		}
+		foundReplacement := false
+		for _, record := range records {
+			for _, exturi := range record.FailureReportAddresses {
+				extr, ok := parseRecipient(log, exturi)
+				if ok && extr.address.Domain == r.address.Domain {
+					foundReplacement = true
+					recipients = append(recipients, extr)
+				}
+			}
+		}
+		if !foundReplacement {
+			recipients = append(recipients, r)
+		}
+	}
+	if len(recipients) == 0 {
+		log.Debug("no failure reporting addresses to send dmarc failure report to", slog.Any("policydomain", fr.PolicyDomain))
+		metricFailureReport.WithLabelValues("norecipient").Inc()
+		return nil
+	}
+
+	db, err := evalDB(ctx)
+	if err != nil {
+		return err
+	}
+
+	fromAddr := smtp.Address{Localpart: "postmaster", Domain: mox.Conf.Static.HostnameDomain}
+
+	report := fr.Report
+	report.FeedbackType = arf.FeedbackAuthFailure
+	report.UserAgent = "mox/" + moxvar.Version
+	report.Version = "1"
+	report.ReportingMTA = mox.Conf.Static.HostnameDomain.ASCII
+	if len(report.ReportedDomain) == 0 {
+		report.ReportedDomain = []string{fr.PolicyDomain.ASCII}
+	}
+
+	subject := fmt.Sprintf("DMARC failure report for %s", fr.PolicyDomain.ASCII)
+	text := fmt.Sprintf(`This is a DMARC failure report for a message with a From address in domain %s,
+received from IP %s at %s.
+
+The original message is redacted: only selected header fields are included, and
+the body is left out or truncated.
+`, fr.PolicyDomain.ASCII, report.SourceIP, report.ArrivalDate.Format(message.RFC5322Z))
+	text = strings.ReplaceAll(text, "\n", "\r\n")
+
+	msgf, err := store.CreateMessageTemp(log, "dmarcfailurereport-out")
+	if err != nil {
+		return fmt.Errorf("creating temporary message file for outgoing dmarc failure report: %v", err)
+	}
+	defer store.CloseRemoveTempFile(log, msgf, "outgoing dmarc failure report message")
+
+	var rcpts []message.NameAddress
+	for _, r := range recipients {
+		rcpts = append(rcpts, message.NameAddress{Address: r.address})
+	}
+
+	msgPrefix, has8bit, smtputf8, messageID, err := composeFailureReport(ctx, log, msgf, fromAddr, rcpts, subject, text, report, fr.Original, fr.HasBody)
+	if err != nil {
+		return err
+	}
+	msgInfo, err := msgf.Stat()
+	if err != nil {
+		return fmt.Errorf("stat message with outgoing dmarc failure report: %v", err)
+	}
+	msgSize := int64(len(msgPrefix)) + msgInfo.Size()
+
+	for _, rcpt := range recipients {
+		if rcpt.maxSize > 0 && uint64(msgSize) > rcpt.maxSize {
+			log.Debug("dmarc failure report larger than maximum size for recipient, skipping", slog.Any("recipient", rcpt.address))
+			continue
+		}
+
+		q := bstore.QueryDB[SuppressAddress](ctx, db)
+		q.FilterNonzero(SuppressAddress{ReportingAddress: rcpt.address.Path().String()})
+		q.FilterGreater("Until", time.Now())
+		exists, err := q.Exists()
+		if err != nil {
+			return fmt.Errorf("querying suppress list: %v", err)
+		}
+		if exists {
+			log.Info("suppressing outgoing dmarc failure report", slog.Any("reportingaddress", rcpt.address))
+			continue
+		}
+
+		qm := queue.MakeMsg(fromAddr.Path(), rcpt.address.Path(), has8bit, smtputf8, msgSize, messageID, []byte(msgPrefix), nil, time.Now(), subject)
+		// Like aggregate reports, don't try as long as regular deliveries, and don't
+		// send DSNs about failed deliveries.
+		qm.MaxAttempts = 5
+		qm.IsDMARCReport = true
+
+		if err := queueAdd(ctx, log, mox.Conf.Static.Postmaster.Account, msgf, qm); err != nil {
+			log.Errorx("queueing message with dmarc failure report", err)
+			metricFailureReport.WithLabelValues("error").Inc()
+		} else {
+			log.Debug("dmarc failure report queued", slog.Any("recipient", rcpt.address))
+			metricFailureReport.WithLabelValues("queued").Inc()
+		}
+	}
+	return nil
+}
+
+func composeFailureReport(ctx context.Context, log mlog.Log, mf *os.File, fromAddr smtp.Address, recipients []message.NameAddress, subject, text string, report arf.Report, original []byte, hasBody bool) (msgPrefix string, has8bit, smtputf8 bool, messageID string, rerr error) {
+	// We only use smtputf8 if we have to, with a utf-8 localpart. For IDNA, we use ASCII domains.
+	smtputf8 = fromAddr.Localpart.IsInternational()
+	for _, r := range recipients {
+		if smtputf8 {
+			smtputf8 = r.Address.Localpart.IsInternational()
+			break
+		}
+	}
+	xc := message.NewComposer(mf, 100*1024*1024, smtputf8)
+	defer func() {
+		x := recover()
+		if x == nil {
+			return
+		}
+		if err, ok := x.(error); ok && errors.Is(err, message.ErrCompose) {
+			rerr = err
+			return
+		}
+		panic(x)
+	}()
+
+	xc.HeaderAddrs("From", []message.NameAddress{{Address: fromAddr}})
+	xc.HeaderAddrs("To", recipients)
+	xc.Subject(subject)
+	messageID = fmt.Sprintf("<%s>", mox.MessageIDGen(xc.SMTPUTF8))
+	xc.Header("Message-Id", messageID)
+	xc.Header("Date", time.Now().Format(message.RFC5322Z))
+	xc.Header("User-Agent", "mox/"+moxvar.Version)
+	xc.Header("MIME-Version", "1.0")
+
+	// Multipart report with human-readable text, the machine-readable report and the
+	// redacted original message.
+	mp := multipart.NewWriter(xc)
+	xc.Header("Content-Type", fmt.Sprintf(`multipart/report; report-type=feedback-report; boundary="%s"`, mp.Boundary()))
+	xc.Line()
+
+	textBody, ct, cte := xc.TextPart("plain", text)
+	textHdr := textproto.MIMEHeader{}
+	textHdr.Set("Content-Type", ct)
+	textHdr.Set("Content-Transfer-Encoding", cte)
+	textp, err := mp.CreatePart(textHdr)
+	xc.Checkf(err, "adding text part to message")
+	_, err = textp.Write(textBody)
+	xc.Checkf(err, "writing text part")
+
+	reportHdr := textproto.MIMEHeader{}
+	reportHdr.Set("Content-Type", "message/feedback-report")
+	reportp, err := mp.CreatePart(reportHdr)
+	xc.Checkf(err, "adding feedback-report part to message")
+	_, err = reportp.Write([]byte(report.Fields()))
+	xc.Checkf(err, "writing feedback-report part")
+
+	// The original message may contain 8bit data.
+	if slices.ContainsFunc(original, func(c byte) bool { return c >= 0x80 }) {
+		xc.Has8bit = true
+	}
+	origHdr := textproto.MIMEHeader{}
+	if hasBody {
+		origHdr.Set("Content-Type", "message/rfc822")
+	} else {
+		origHdr.Set("Content-Type", "text/rfc822-headers")
+	}
+	origHdr.Set("Content-Disposition", "inline")
+	origp, err := mp.CreatePart(origHdr)
+	xc.Checkf(err, "adding original message part to message")
+	_, err = origp.Write(original)
+	xc.Checkf(err, "writing original message part")
+
+	err = mp.Close()
+	xc.Checkf(err, "closing multipart")
+
+	xc.Flush()
+
+	msgPrefix = dkimSign(ctx, log, fromAddr, xc.SMTPUTF8, mf)
+
+	return msgPrefix, xc.Has8bit, xc.SMTPUTF8, messageID, nil
+}
@@ -0,0 +1,150 @@
+package dmarcdb
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/arf"
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/dmarc"
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/queue"
+)
+
+func TestFailureReport(t *testing.T) {
+	os.RemoveAll("../testdata/dmarcdb/data")
+	mox.Context = ctxbg
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/dmarcdb/mox.conf")
+	mox.MustLoadConfig(true, false)
+	EvalDB = nil
+
+	_, err := evalDB(ctxbg)
+	tcheckf(t, err, "database")
+	defer func() {
+		EvalDB.Close()
+		EvalDB = nil
+		mox.Conf.Static.DMARCFailureReports = nil
+	}()
+
+	log := mlog.New("dmarcdb", nil)
+
+	msg := strings.ReplaceAll(`Received: from remote.example by mail.mox.example
+From: <sender@sub.sender.example>
+To: <rcpt@mox.example>
+Subject: test message with a long subject that gets truncated
+Message-ID: <test@sender.example>
+DKIM-Signature: v=1; d=sender.example; s=sel;
+	b=abc
+
+line 1
+line 2
+`, "\n", "\r\n")
+
+	dc := config.DMARCFailureReportsDomain{
+		Headers:       []string{"From", "subject", "message-id", "dkim-signature"},
+		MaxHeaderSize: 40,
+		MaxPerHour:    2,
+		Domain:        dns.Domain{ASCII: "sender.example"},
+	}
+
+	// Only selected headers, truncated, no body.
+	original, hasBody, err := FailureReportRedact(dc, strings.NewReader(msg))
+	tcheckf(t, err, "redact")
+	expOriginal := "From: <sender@sub.sender.example>\r\nSubject: test message with a long subjec\r\nMessage-ID: <test@sender.example>\r\nDKIM-Signature: v=1; d=sender.example; s\r\n"
+	tcompare(t, string(original), expOriginal)
+	tcompare(t, hasBody, false)
+
+	// With start of body, partial line is dropped.
+	dc.BodySize = 10
+	original, hasBody, err = FailureReportRedact(dc, strings.NewReader(msg))
+	tcheckf(t, err, "redact")
+	tcompare(t, string(original), expOriginal+"\r\nline 1\r\n")
+	tcompare(t, hasBody, true)
+
+	mox.Conf.Static.DMARCFailureReports = &config.DMARCFailureReports{
+		Domains: map[string]config.DMARCFailureReportsDomain{"sender.example": dc},
+	}
+
+	// Subdomains match the configured domain.
+	_, ok := FailureReportDomain(dns.Domain{ASCII: "sub.sender.example"})
+	tcompare(t, ok, true)
+	_, ok = FailureReportDomain(dns.Domain{ASCII: "other.example"})
+	tcompare(t, ok, false)
+
+	resolver := dns.MockResolver{
+		TXT: map[string][]string{
+			"sender.example._report._dmarc.accepting.example.": {"v=DMARC1"},
+		},
+	}
+
+	var queued []string
+	queueAdd = func(ctx context.Context, log mlog.Log, senderAccount string, msgFile *os.File, qml ...queue.Msg) error {
+		if len(qml) != 1 {
+			return fmt.Errorf("queued %d messages, expected 1", len(qml))
+		}
+		queued = append(queued, qml[0].Recipient().String())
+
+		r, err := arf.Parse(log.Logger, msgFile)
+		tcheckf(t, err, "parsing failure report")
+		tcompare(t, r.FeedbackType, arf.FeedbackAuthFailure)
+		tcompare(t, r.AuthFailure, "dmarc")
+		tcompare(t, r.SourceIP, "10.1.2.3")
+		tcompare(t, r.ReportedDomain, []string{"sender.example"})
+		tcompare(t, r.OriginalMessageID, "<test@sender.example>")
+		tcompare(t, len(r.OriginalRcptTo), 0)
+		return nil
+	}
+	defer func() {
+		queueAdd = queue.Add
+	}()
+
+	record := dmarc.DefaultRecord
+	record.FailureReportAddresses = []dmarc.URI{
+		{Address: "mailto:ruf@sender.example"},
+		{Address: "mailto:ruf@accepting.example"},
+		{Address: "mailto:ruf@other.example"},              // Not opted in, skipped.
+		{Address: "mailto:ruf@sender.example", MaxSize: 1}, // Too small, skipped.
+	}
+	fr := FailureReport{
+		PolicyDomain: dns.Domain{ASCII: "sender.example"},
+		Record:       &record,
+		Report: arf.Report{
+			AuthFailure:    "dmarc",
+			SourceIP:       "10.1.2.3",
+			ArrivalDate:    time.Now(),
+			DeliveryResult: "reject",
+		},
+		Original: original,
+		HasBody:  hasBody,
+	}
+	err = SendFailureReport(ctxbg, log, resolver, fr)
+	tcheckf(t, err, "send failure report")
+	tcompare(t, queued, []string{"ruf@sender.example", "ruf@accepting.example"})
+
+	// Suppressed address is skipped.
+	queued = nil
+	err = SuppressAdd(ctxbg, &SuppressAddress{ReportingAddress: "ruf@accepting.example", Until: time.Now().Add(time.Hour)})
+	tcheckf(t, err, "add suppressed address")
+	err = SendFailureReport(ctxbg, log, resolver, fr)
+	tcheckf(t, err, "send failure report")
+	tcompare(t, queued, []string{"ruf@sender.example"})
+
+	// Hourly limit reached.
+	queued = nil
+	err = SendFailureReport(ctxbg, log, resolver, fr)
+	tcheckf(t, err, "send failure report")
+	tcompare(t, len(queued), 0)
+
+	// Not configured.
+	fr.PolicyDomain = dns.Domain{ASCII: "other.example"}
+	err = SendFailureReport(ctxbg, log, resolver, fr)
+	tcheckf(t, err, "send failure report")
+	tcompare(t, len(queued), 0)
+}
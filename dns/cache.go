@@ -0,0 +1,287 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/mjl-/adns"
+
+	"github.com/mjl-/mox/stub"
+)
+
+var (
+	MetricCache stub.CounterVec = stub.CounterVecIgnore{}
+)
+
+// Cache keeps results of DNS lookups in memory, for use by multiple resolvers, see
+// Cache.Resolver. Successful lookups, including those without records, are kept
+// for TTL. Lookups of names that don't exist are kept for NegativeTTL. Other
+// errors, e.g. temporary failures and timeouts, are not kept. Concurrent
+// identical lookups are combined into a single lookup.
+//
+// Lookup results don't include TTLs of the DNS records, so results are kept for
+// a fixed duration, which should be lower than typical TTLs.
+type Cache struct {
+	ttl         time.Duration
+	negativeTTL time.Duration
+	maxEntries  int
+
+	sync.Mutex
+	entries map[string]*cacheEntry
+}
+
+type cacheEntry struct {
+	ready   chan struct{} // Closed when lookup has finished and fields below are set.
+	value   any
+	result  adns.Result
+	err     error
+	expires time.Time // Zero if result must not be kept, e.g. for temporary errors.
+}
+
+// NewCache returns a new cache that keeps at most maxEntries lookup results.
+func NewCache(ttl, negativeTTL time.Duration, maxEntries int) *Cache {
+	return &Cache{ttl: ttl, negativeTTL: negativeTTL, maxEntries: maxEntries, entries: map[string]*cacheEntry{}}
+}
+
+// Resolver returns a resolver that answers from the cache, and does lookups
+// that are not in the cache with r.
+func (c *Cache) Resolver(r Resolver) Resolver {
+	return cacheResolver{c, r}
+}
+
+// Len returns the number of lookup results in the cache, including lookups in
+// progress.
+func (c *Cache) Len() int {
+	c.Lock()
+	defer c.Unlock()
+	return len(c.entries)
+}
+
+// lookup returns the cached result for key, or calls fn to look it up. If a lookup
+// for key is in progress, its result is used.
+func (c *Cache) lookup(ctx context.Context, key string, fn func() (any, adns.Result, error)) (any, adns.Result, error) {
+	now := time.Now()
+
+	c.Lock()
+	e, ok := c.entries[key]
+	if ok {
+		select {
+		case <-e.ready:
+			if now.Before(e.expires) {
+				c.Unlock()
+				if e.err != nil {
+					MetricCache.IncLabels("negative")
+				} else {
+					MetricCache.IncLabels("hit")
+				}
+				return e.value, e.result, e.err
+			}
+			ok = false
+		default:
+		}
+	}
+	if ok {
+		c.Unlock()
+		// Wait for lookup in progress.
+		select {
+		case <-ctx.Done():
+			return nil, adns.Result{}, ctx.Err()
+		case <-e.ready:
+		}
+		if !e.expires.IsZero() {
+			MetricCache.IncLabels("shared")
+			return e.value, e.result, e.err
+		}
+		// Lookup failed with an error we don't share, e.g. a timeout for the context of
+		// the other lookup. We do our own lookup.
+		MetricCache.IncLabels("miss")
+		return fn()
+	}
+
+	if len(c.entries) >= c.maxEntries {
+		c.evict(now)
+	}
+	e = &cacheEntry{ready: make(chan struct{})}
+	c.entries[key] = e
+	c.Unlock()
+
+	MetricCache.IncLabels("miss")
+	defer func() {
+		c.Lock()
+		defer c.Unlock()
+		if e.expires.IsZero() && c.entries[key] == e {
+			delete(c.entries, key)
+		}
+		close(e.ready)
+	}()
+
+	e.value, e.result, e.err = fn()
+	if e.err == nil {
+		e.expires = time.Now().Add(c.ttl)
+	} else if IsNotFound(e.err) {
+		e.expires = time.Now().Add(c.negativeTTL)
+	}
+	return e.value, e.result, e.err
+}
+
+// evict removes expired entries, and if that isn't enough, arbitrary entries.
+// Must be called with lock held.
+func (c *Cache) evict(now time.Time) {
+	for k, e := range c.entries {
+		select {
+		case <-e.ready:
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+			}
+		default:
+		}
+	}
+	for k := range c.entries {
+		if len(c.entries) < c.maxEntries {
+			break
+		}
+		delete(c.entries, k)
+	}
+}
+
+type cacheResolver struct {
+	c *Cache
+	r Resolver
+}
+
+var _ Resolver = cacheResolver{}
+
+// cached looks up key in the cache or calls fn, and returns a copy of the value
+// made with clone so callers can modify it.
+func cached[T any](ctx context.Context, c *Cache, key string, clone func(T) T, fn func() (T, adns.Result, error)) (T, adns.Result, error) {
+	v, result, err := c.lookup(ctx, key, func() (any, adns.Result, error) {
+		return fn()
+	})
+	x, ok := v.(T)
+	if v != nil && !ok {
+		var zero T
+		return zero, result, errors.New("dns: unexpected type in cache")
+	}
+	return clone(x), result, err
+}
+
+func (r cacheResolver) LookupPort(ctx context.Context, network, service string) (port int, err error) {
+	return r.r.LookupPort(ctx, network, service)
+}
+
+func (r cacheResolver) LookupAddr(ctx context.Context, addr string) ([]string, adns.Result, error) {
+	return cached(ctx, r.c, "ptr "+addr, slices.Clone[[]string], func() ([]string, adns.Result, error) {
+		return r.r.LookupAddr(ctx, addr)
+	})
+}
+
+func (r cacheResolver) LookupCNAME(ctx context.Context, host string) (string, adns.Result, error) {
+	return cached(ctx, r.c, "cname "+host, func(s string) string { return s }, func() (string, adns.Result, error) {
+		return r.r.LookupCNAME(ctx, host)
+	})
+}
+
+func (r cacheResolver) LookupHost(ctx context.Context, host string) ([]string, adns.Result, error) {
+	return cached(ctx, r.c, "host "+host, slices.Clone[[]string], func() ([]string, adns.Result, error) {
+		return r.r.LookupHost(ctx, host)
+	})
+}
+
+func (r cacheResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, adns.Result, error) {
+	clone := func(l []net.IP) []net.IP {
+		var nl []net.IP
+		for _, ip := range l {
+			nl = append(nl, slices.Clone(ip))
+		}
+		return nl
+	}
+	return cached(ctx, r.c, "ip "+network+" "+host, clone, func() ([]net.IP, adns.Result, error) {
+		return r.r.LookupIP(ctx, network, host)
+	})
+}
+
+func (r cacheResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, adns.Result, error) {
+	clone := func(l []net.IPAddr) []net.IPAddr {
+		var nl []net.IPAddr
+		for _, ip := range l {
+			nl = append(nl, net.IPAddr{IP: slices.Clone(ip.IP), Zone: ip.Zone})
+		}
+		return nl
+	}
+	return cached(ctx, r.c, "ipaddr "+host, clone, func() ([]net.IPAddr, adns.Result, error) {
+		return r.r.LookupIPAddr(ctx, host)
+	})
+}
+
+func (r cacheResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, adns.Result, error) {
+	clone := func(l []*net.MX) []*net.MX {
+		var nl []*net.MX
+		for _, mx := range l {
+			x := *mx
+			nl = append(nl, &x)
+		}
+		return nl
+	}
+	return cached(ctx, r.c, "mx "+name, clone, func() ([]*net.MX, adns.Result, error) {
+		return r.r.LookupMX(ctx, name)
+	})
+}
+
+func (r cacheResolver) LookupNS(ctx context.Context, name string) ([]*net.NS, adns.Result, error) {
+	clone := func(l []*net.NS) []*net.NS {
+		var nl []*net.NS
+		for _, ns := range l {
+			x := *ns
+			nl = append(nl, &x)
+		}
+		return nl
+	}
+	return cached(ctx, r.c, "ns "+name, clone, func() ([]*net.NS, adns.Result, error) {
+		return r.r.LookupNS(ctx, name)
+	})
+}
+
+func (r cacheResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, adns.Result, error) {
+	type srv struct {
+		cname string
+		addrs []*net.SRV
+	}
+	clone := func(v srv) srv {
+		var nl []*net.SRV
+		for _, s := range v.addrs {
+			x := *s
+			nl = append(nl, &x)
+		}
+		return srv{v.cname, nl}
+	}
+	v, result, err := cached(ctx, r.c, fmt.Sprintf("srv %s %s %s", service, proto, name), clone, func() (srv, adns.Result, error) {
+		cname, addrs, result, err := r.r.LookupSRV(ctx, service, proto, name)
+		return srv{cname, addrs}, result, err
+	})
+	return v.cname, v.addrs, result, err
+}
+
+func (r cacheResolver) LookupTXT(ctx context.Context, name string) ([]string, adns.Result, error) {
+	return cached(ctx, r.c, "txt "+name, slices.Clone[[]string], func() ([]string, adns.Result, error) {
+		return r.r.LookupTXT(ctx, name)
+	})
+}
+
+func (r cacheResolver) LookupTLSA(ctx context.Context, port int, protocol, host string) ([]adns.TLSA, adns.Result, error) {
+	clone := func(l []adns.TLSA) []adns.TLSA {
+		var nl []adns.TLSA
+		for _, t := range l {
+			t.CertAssoc = slices.Clone(t.CertAssoc)
+			nl = append(nl, t)
+		}
+		return nl
+	}
+	return cached(ctx, r.c, fmt.Sprintf("tlsa %d %s %s", port, protocol, host), clone, func() ([]adns.TLSA, adns.Result, error) {
+		return r.r.LookupTLSA(ctx, port, protocol, host)
+	})
+}
@@ -0,0 +1,97 @@
+package dns
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mjl-/adns"
+)
+
+// countResolver counts TXT lookups, and blocks them while block is locked.
+type countResolver struct {
+	MockResolver
+	n     *atomic.Int32
+	block *sync.Mutex
+}
+
+func (r countResolver) LookupTXT(ctx context.Context, name string) ([]string, adns.Result, error) {
+	r.n.Add(1)
+	r.block.Lock()
+	defer r.block.Unlock()
+	return r.MockResolver.LookupTXT(ctx, name)
+}
+
+func TestCache(t *testing.T) {
+	ctx := context.Background()
+	mock := MockResolver{
+		TXT: map[string][]string{
+			"a.example.": {"v=spf1 -all"},
+		},
+		Fail: []string{"txt fail.example."},
+	}
+	var n atomic.Int32
+	var block sync.Mutex
+	cache := NewCache(time.Minute, 50*time.Millisecond, 3)
+	r := cache.Resolver(countResolver{mock, &n, &block})
+
+	lookup := func(name string, expTXT []string, expErr bool, expN int32) {
+		t.Helper()
+		l, _, err := r.LookupTXT(ctx, name)
+		if (err != nil) != expErr {
+			t.Fatalf("lookup %s: got err %v, expected error %v", name, err, expErr)
+		}
+		if len(l) != len(expTXT) || len(l) > 0 && l[0] != expTXT[0] {
+			t.Fatalf("lookup %s: got %v, expected %v", name, l, expTXT)
+		}
+		if got := n.Load(); got != expN {
+			t.Fatalf("lookup %s: got %d lookups, expected %d", name, got, expN)
+		}
+	}
+
+	// Positive result is cached, and callers get a copy.
+	lookup("a.example.", []string{"v=spf1 -all"}, false, 1)
+	l, _, _ := r.LookupTXT(ctx, "a.example.")
+	l[0] = "modified"
+	lookup("a.example.", []string{"v=spf1 -all"}, false, 1)
+
+	// Negative result is cached, for a shorter time.
+	lookup("b.example.", nil, true, 2)
+	lookup("b.example.", nil, true, 2)
+	time.Sleep(60 * time.Millisecond)
+	lookup("b.example.", nil, true, 3)
+
+	// Temporary errors are not cached.
+	lookup("fail.example.", nil, true, 4)
+	lookup("fail.example.", nil, true, 5)
+
+	// Concurrent lookups are combined.
+	block.Lock()
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l, _, err := r.LookupTXT(ctx, "c.example.")
+			if err == nil || len(l) != 0 {
+				t.Errorf("concurrent lookup: got %v, %v, expected not found", l, err)
+			}
+		}()
+	}
+	for cache.Len() < 3 || n.Load() < 6 {
+		time.Sleep(time.Millisecond)
+	}
+	block.Unlock()
+	wg.Wait()
+	if got := n.Load(); got != 6 {
+		t.Fatalf("got %d lookups after concurrent lookups, expected 6", got)
+	}
+
+	// Cache size is limited.
+	lookup("d.example.", nil, true, 7)
+	if got := cache.Len(); got > 3 {
+		t.Fatalf("cache has %d entries, expected at most 3", got)
+	}
+}
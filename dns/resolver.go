@@ -15,6 +15,7 @@ import (
 
 	"github.com/mjl-/mox/mlog"
 	"github.com/mjl-/mox/stub"
+	"github.com/mjl-/mox/tracing"
 )
 
 // todo future: replace with a dnssec capable resolver
@@ -95,6 +96,16 @@ func metricLookupObserve(pkg, typ string, err error, start time.Time) {
 	MetricLookup.ObserveLabels(float64(time.Since(start))/float64(time.Second), pkg, typ, result)
 }
 
+// traceLookup records a span for a DNS lookup, if tracing is enabled.
+func traceLookup(ctx context.Context, pkg, typ, name string, authentic bool, err error, start time.Time) {
+	tracing.Record(ctx, "dns.lookup", tracing.KindClient, start, err,
+		tracing.String("dns.pkg", pkg),
+		tracing.String("dns.type", typ),
+		tracing.String("dns.name", name),
+		tracing.Bool("dns.authentic", authentic),
+	)
+}
+
 func (r StrictResolver) WithPackage(name string) Resolver {
 	nr := r
 	nr.Pkg = name
@@ -128,6 +139,7 @@ func (r StrictResolver) LookupPort(ctx context.Context, network, service string)
 	start := time.Now()
 	defer func() {
 		metricLookupObserve(r.Pkg, "port", err, start)
+		traceLookup(ctx, r.Pkg, "port", service, false, err, start)
 		r.log().WithContext(ctx).Debugx("dns lookup result", err,
 			slog.String("type", "port"),
 			slog.String("network", network),
@@ -146,6 +158,7 @@ func (r StrictResolver) LookupAddr(ctx context.Context, addr string) (resp []str
 	start := time.Now()
 	defer func() {
 		metricLookupObserve(r.Pkg, "addr", err, start)
+		traceLookup(ctx, r.Pkg, "addr", addr, result.Authentic, err, start)
 		r.log().WithContext(ctx).Debugx("dns lookup result", err,
 			slog.String("type", "addr"),
 			slog.String("addr", addr),
@@ -172,6 +185,7 @@ func (r StrictResolver) LookupCNAME(ctx context.Context, host string) (resp stri
 	start := time.Now()
 	defer func() {
 		metricLookupObserve(r.Pkg, "cname", err, start)
+		traceLookup(ctx, r.Pkg, "cname", host, result.Authentic, err, start)
 		r.log().WithContext(ctx).Debugx("dns lookup result", err,
 			slog.String("type", "cname"),
 			slog.String("host", host),
@@ -201,6 +215,7 @@ func (r StrictResolver) LookupHost(ctx context.Context, host string) (resp []str
 	start := time.Now()
 	defer func() {
 		metricLookupObserve(r.Pkg, "host", err, start)
+		traceLookup(ctx, r.Pkg, "host", host, result.Authentic, err, start)
 		r.log().WithContext(ctx).Debugx("dns lookup result", err,
 			slog.String("type", "host"),
 			slog.String("host", host),
@@ -222,6 +237,7 @@ func (r StrictResolver) LookupIP(ctx context.Context, network, host string) (res
 	start := time.Now()
 	defer func() {
 		metricLookupObserve(r.Pkg, "ip", err, start)
+		traceLookup(ctx, r.Pkg, "ip", host, result.Authentic, err, start)
 		r.log().WithContext(ctx).Debugx("dns lookup result", err,
 			slog.String("type", "ip"),
 			slog.String("network", network),
@@ -244,6 +260,7 @@ func (r StrictResolver) LookupIPAddr(ctx context.Context, host string) (resp []n
 	start := time.Now()
 	defer func() {
 		metricLookupObserve(r.Pkg, "ipaddr", err, start)
+		traceLookup(ctx, r.Pkg, "ipaddr", host, result.Authentic, err, start)
 		r.log().WithContext(ctx).Debugx("dns lookup result", err,
 			slog.String("type", "ipaddr"),
 			slog.String("host", host),
@@ -265,6 +282,7 @@ func (r StrictResolver) LookupMX(ctx context.Context, name string) (resp []*net.
 	start := time.Now()
 	defer func() {
 		metricLookupObserve(r.Pkg, "mx", err, start)
+		traceLookup(ctx, r.Pkg, "mx", name, result.Authentic, err, start)
 		r.log().WithContext(ctx).Debugx("dns lookup result", err,
 			slog.String("type", "mx"),
 			slog.String("name", name),
@@ -286,6 +304,7 @@ func (r StrictResolver) LookupNS(ctx context.Context, name string) (resp []*net.
 	start := time.Now()
 	defer func() {
 		metricLookupObserve(r.Pkg, "ns", err, start)
+		traceLookup(ctx, r.Pkg, "ns", name, result.Authentic, err, start)
 		r.log().WithContext(ctx).Debugx("dns lookup result", err,
 			slog.String("type", "ns"),
 			slog.String("name", name),
@@ -307,6 +326,7 @@ func (r StrictResolver) LookupSRV(ctx context.Context, service, proto, name stri
 	start := time.Now()
 	defer func() {
 		metricLookupObserve(r.Pkg, "srv", err, start)
+		traceLookup(ctx, r.Pkg, "srv", name, result.Authentic, err, start)
 		r.log().WithContext(ctx).Debugx("dns lookup result", err,
 			slog.String("type", "srv"),
 			slog.String("service", service),
@@ -331,6 +351,7 @@ func (r StrictResolver) LookupTXT(ctx context.Context, name string) (resp []stri
 	start := time.Now()
 	defer func() {
 		metricLookupObserve(r.Pkg, "txt", err, start)
+		traceLookup(ctx, r.Pkg, "txt", name, result.Authentic, err, start)
 		r.log().WithContext(ctx).Debugx("dns lookup result", err,
 			slog.String("type", "txt"),
 			slog.String("name", name),
@@ -352,6 +373,7 @@ func (r StrictResolver) LookupTLSA(ctx context.Context, port int, protocol, host
 	start := time.Now()
 	defer func() {
 		metricLookupObserve(r.Pkg, "tlsa", err, start)
+		traceLookup(ctx, r.Pkg, "tlsa", host, result.Authentic, err, start)
 		r.log().WithContext(ctx).Debugx("dns lookup result", err,
 			slog.String("type", "tlsa"),
 			slog.Int("port", port),
@@ -0,0 +1,89 @@
+package dnsbl
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mjl-/mox/dns"
+)
+
+// Delist describes how to get an IP removed from a well-known DNS block list.
+type Delist struct {
+	Name      string // Name of the block list operator, e.g. "Spamhaus".
+	URL       string // Page for looking up a listing and requesting removal.
+	Procedure string // Short description of the delisting procedure.
+}
+
+// Well-known block lists, matched on zone or parent domain of the zone. Policies
+// change over time, the procedures only point in the right direction.
+var delists = map[string]Delist{
+	"spamhaus.org": {
+		"Spamhaus",
+		"https://check.spamhaus.org/",
+		"Look up the IP to see in which of the Spamhaus lists (SBL, XBL, PBL, CSS) it is listed and why. Most listings can be removed through the lookup page once the cause is fixed. IPs in the PBL are in address ranges that are not supposed to send email directly, removal can be requested for a mail server with proper reverse DNS.",
+	},
+	"spamhaus.net": {
+		"Spamhaus",
+		"https://check.spamhaus.org/",
+		"Look up the IP to see in which of the Spamhaus lists (SBL, XBL, PBL, CSS) it is listed and why. Most listings can be removed through the lookup page once the cause is fixed.",
+	},
+	"spamcop.net": {
+		"SpamCop",
+		"https://www.spamcop.net/bl.shtml",
+		"Listings are based on spam reports and expire automatically about 24 hours after the last report. There is no manual removal, stop the spam and wait.",
+	},
+	"barracudacentral.org": {
+		"Barracuda",
+		"https://www.barracudacentral.org/rbl/removal-request",
+		"Submit a removal request with the IP, an email address and the reason for the listing. Requests are typically processed within a day.",
+	},
+	"mailspike.net": {
+		"Mailspike",
+		"https://mailspike.org/iplookup.html",
+		"Look up the IP, a removal can be requested from the lookup page. Reputation also improves automatically when no more spam is seen.",
+	},
+	"surriel.com": {
+		"PSBL",
+		"https://psbl.org/remove",
+		"Remove the IP through the removal page, which is immediate. IPs are listed again if more spam arrives at the spamtraps.",
+	},
+	"uceprotect.net": {
+		"UCEPROTECT",
+		"https://www.uceprotect.net/en/rblcheck.php",
+		"Level 1 listings of single IPs expire automatically 7 days after the last spam. Levels 2 and 3 list entire networks and providers, contact your hosting provider.",
+	},
+	"manitu.net": {
+		"NiX Spam",
+		"https://www.dnsbl.manitu.net/",
+		"Listings expire automatically after 12 hours without spam. A removal can be requested on the lookup page.",
+	},
+}
+
+// DelistInfo returns information about removing an IP from the block list of
+// zone. If the zone is not a well-known block list, false is returned.
+func DelistInfo(zone dns.Domain) (Delist, bool) {
+	s := strings.TrimSuffix(strings.ToLower(zone.ASCII), ".")
+	for s != "" {
+		if d, ok := delists[s]; ok {
+			return d, true
+		}
+		_, s, _ = strings.Cut(s, ".")
+	}
+	return Delist{}, false
+}
+
+// DelistText returns guidance for getting ip removed from the block list of zone,
+// for printing or including in an alert. The text can be multiple lines.
+func DelistText(zone dns.Domain, ip string) string {
+	text := fmt.Sprintf(`Before requesting removal of %s from %s, find and fix the cause of the
+listing, or the IP will be listed again: check the outgoing queue and message
+history for unexpected messages, e.g. from an account with a compromised
+password, and make sure there is no open relay or other software sending email
+from the IP.`, ip, zone)
+	if d, ok := DelistInfo(zone); ok {
+		text += fmt.Sprintf("\n\nDelisting procedure of %s, %s:\n%s", d.Name, d.URL, d.Procedure)
+	} else {
+		text += "\n\nLook up the delisting procedure on the website of the block list."
+	}
+	return text
+}
@@ -64,3 +64,18 @@ func TestDNSBL(t *testing.T) {
 		t.Fatalf("bad dnsbl is healthy")
 	}
 }
+
+func TestDelistInfo(t *testing.T) {
+	if d, ok := DelistInfo(dns.Domain{ASCII: "zen.spamhaus.org"}); !ok || d.Name != "Spamhaus" {
+		t.Fatalf("delist info for zen.spamhaus.org, got %v %v", d, ok)
+	}
+	if d, ok := DelistInfo(dns.Domain{ASCII: "bl.spamcop.net"}); !ok || d.Name != "SpamCop" {
+		t.Fatalf("delist info for bl.spamcop.net, got %v %v", d, ok)
+	}
+	if _, ok := DelistInfo(dns.Domain{ASCII: "dnsbl.example"}); ok {
+		t.Fatalf("delist info for unknown zone")
+	}
+	if _, ok := DelistInfo(dns.Domain{ASCII: "net"}); ok {
+		t.Fatalf("delist info for tld")
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/mjl-/mox/admindb"
+	"github.com/mjl-/mox/alert"
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/dnsbl"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+)
+
+// dnsblMonitorZones returns the DNSBL zones to check our IPs against: those of the
+// public listener, and those configured only for monitoring.
+func dnsblMonitorZones() []dns.Domain {
+	zones := append([]dns.Domain{}, mox.Conf.Static.Listeners["public"].SMTP.DNSBLZones...)
+	for _, zone := range mox.Conf.DynamicConfig().MonitorDNSBLZones {
+		if !slices.Contains(zones, zone) {
+			zones = append(zones, zone)
+		}
+	}
+	return zones
+}
+
+// dnsblMonitorIPs returns the public IPs we may send from.
+func dnsblMonitorIPs(ctx context.Context) ([]net.IP, error) {
+	ips, err := mox.IPs(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	var l []net.IP
+	for _, ip := range ips {
+		if !ip.IsLoopback() && !ip.IsPrivate() {
+			l = append(l, ip)
+		}
+	}
+	return l, nil
+}
+
+// dnsblMonitorLookup checks whether ip is in the block list of zone. On a
+// successful lookup, an alert is raised or resolved, and a change in status is
+// recorded in the history.
+func dnsblMonitorLookup(ctx context.Context, log mlog.Log, resolver dns.Resolver, zone dns.Domain, ip net.IP) (dnsbl.Status, string, error) {
+	status, expl, err := dnsbl.Lookup(ctx, log.Logger, resolver, zone, ip)
+	if status != dnsbl.StatusPass && status != dnsbl.StatusFail {
+		return status, expl, err
+	}
+	listed := status == dnsbl.StatusFail
+	alert.DNSBLStatus(log, zone, ip.String(), listed, expl)
+	changed, xerr := admindb.DNSBLRecord(ctx, zone.Name(), ip.String(), listed, expl, time.Now())
+	if xerr != nil {
+		log.Errorx("recording dnsbl status", xerr, slog.Any("zone", zone), slog.Any("ip", ip))
+	} else if changed && listed {
+		log.Error("ip listed in dnsbl", slog.Any("zone", zone), slog.Any("ip", ip), slog.String("explanation", expl))
+	} else if changed {
+		log.Info("ip not listed in dnsbl", slog.Any("zone", zone), slog.Any("ip", ip))
+	}
+	return status, expl, err
+}
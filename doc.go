@@ -9,6 +9,20 @@ Many of those commands talk to a running mox instance, through the ctl file in
 the data directory. Specify the configuration file (that holds the path to the
 data directory) through the -config flag or MOXCONF environment variable.
 
+Some commands for managing domains, accounts, addresses, aliases, the queue and
+log levels can also be run against a remote mox instance, through its admin API,
+with the -remote flag, e.g. "mox -remote https://mail.example.com/adminapi/
+queue list". The admin API must be enabled in a listener, and the API token,
+see "mox config apitoken add", must be set in the MOXTOKEN environment
+variable. Only these commands can be run with -remote: config domain add,
+config domain rm, config account add, config account rm, config address add,
+config address rm, config alias list, config alias print, config alias add,
+config alias update, config alias rm, config alias addaddr, config alias rmaddr,
+config apply, setaccountpassword, loglevels, queue list, queue hold, queue
+unhold, queue schedule, queue drop and usage. Other commands, e.g. for backups,
+imports, and the queue hold rules, need local access to the control socket or
+data directory, and fail with -remote.
+
 Commands that don't talk to a running mox instance are often for
 testing/debugging email functionality. For example for parsing an email message,
 or looking up SPF/DKIM/DMARC records.
@@ -18,13 +32,20 @@ any parameters. Followed by the help and usage information for each command.
 
 # Usage
 
-	mox [-config config/mox.conf] [-pedantic] ...
+	mox [-config config/mox.conf] [-pedantic] [-remote url] ...
 	mox serve
-	mox quickstart [-skipdial] [-existing-webserver] [-hostname host] user@domain [user | uid]
+	mox quickstart [-skipdial] [-existing-webserver] [-hostname host] [-public-ip ip ...] [-admin-password-file file] [-account-password-file file] [-output systemd|docker-compose|k8s|cloud-init|none] [-json] user@domain ... [user | uid]
 	mox stop
 	mox setaccountpassword account
 	mox setadminpassword
+	mox setadmintotp [-disable]
 	mox loglevels [level [pkg]]
+	mox healthcheck
+	mox doctor
+	mox checksend [-from address] [-all] address
+	mox checkreceive [-timeout duration] from-address echo-address
+	mox top [-interval duration]
+	mox admin reload
 	mox queue holdrules list
 	mox queue holdrules add [ruleflags]
 	mox queue holdrules remove ruleid
@@ -51,13 +72,32 @@ any parameters. Followed by the help and usage information for each command.
 	mox queue webhook retired print id
 	mox import maildir accountname mailboxname maildir
 	mox import mbox accountname mailboxname mbox
-	mox export maildir [-single] dst-dir account-path [mailbox]
-	mox export mbox [-single] dst-dir account-path [mailbox]
+	mox import eml accountname mailboxname dir
+	mox import pst [-prefix mailbox] accountname file.pst
+	mox import imap [-starttls] [-prefix mailbox] accountname host[:port] username
+	mox export maildir [-single] [-include pattern] [-exclude pattern] [-after date] [-before date] [-flag flag] [-notflag flag] dst-dir account-path [mailbox]
+	mox export mbox [-single] [-include pattern] [-exclude pattern] [-after date] [-before date] [-flag flag] [-notflag flag] dst-dir account-path [mailbox]
+	mox export eml [-single] [-include pattern] [-exclude pattern] [-after date] [-before date] [-flag flag] [-notflag flag] dst-dir account-path [mailbox]
 	mox localserve
 	mox help [command ...]
-	mox backup dest-dir
+	mox backup [-verbose] [-incremental previous] [-manifest file] dest-dir
 	mox verifydata data-dir
+	mox verifybackup [-deep] backup
+	mox extractbackup dest-dir backup.tar [incremental.tar ...]
+	mox restore [-mailbox name] [-prefix mailbox] backup-dir account
+	mox fsck [-deep] [-fix kinds] [account]
+	mox account takeout accountname dst.tgz
+	mox account delete request [-grace duration] accountname
+	mox account delete cancel accountname
+	mox account delete purge accountname
+	mox account delete list
+	mox usage [-start yyyy-mm] [-end yyyy-mm] [-account account] [-domain domain] [-group account|domain] [-format text|csv|json]
+	mox replication standby [-name name] [-interval duration] -tokenfile file primary-url data-dir
+	mox replication status [standby-data-dir]
+	mox preflight listen [-skipdial] [-wait duration]
+	mox preflight check [-ports ports] [-timeout duration] host token
 	mox config test
+	mox config migrate [-dryrun]
 	mox config dnscheck domain
 	mox config dnsrecords domain
 	mox config describe-domains >domains.conf
@@ -68,6 +108,7 @@ any parameters. Followed by the help and usage information for each command.
 	mox config address rm address
 	mox config domain add domain account [localpart]
 	mox config domain rm domain
+	mox config addresses [domain ...]
 	mox config alias list domain
 	mox config alias print alias
 	mox config alias add alias@domain rcpt1@domain ...
@@ -75,6 +116,10 @@ any parameters. Followed by the help and usage information for each command.
 	mox config alias rm alias@domain
 	mox config alias addaddr alias@domain rcpt1@domain ...
 	mox config alias rmaddr alias@domain rcpt1@domain ...
+	mox config apitoken list
+	mox config apitoken add [-role role] name
+	mox config apitoken rm name
+	mox config apply [-dryrun] domains.conf
 	mox config describe-sendmail >/etc/moxsubmit.conf
 	mox config printservice >mox.service
 	mox config ensureacmehostprivatekeys
@@ -98,8 +143,10 @@ any parameters. Followed by the help and usage information for each command.
 	mox dmarc checkreportaddrs domain
 	mox dnsbl check zone ip
 	mox dnsbl checkhealth zone
+	mox dnsbl monitor [-check] [-history n]
+	mox ech genkey publicname >ech.$publicname.pem
 	mox mtasts lookup domain
-	mox retrain accountname
+	mox retrain [-backlog] accountname
 	mox sendmail [-Fname] [ignoredflags] [-t] [<message]
 	mox spf check domain ip
 	mox spf lookup domain
@@ -117,7 +164,12 @@ any parameters. Followed by the help and usage information for each command.
 	mox ensureparsed account
 	mox recalculatemailboxcounts account
 	mox message parse message.eml
+	mox message trace id
 	mox reassignthreads [account]
+	mox reindex [-steps parse,threads,counts] [-restart] [account]
+	mox upgradeaccounts [account]
+	mox compressmessages [account]
+	mox dedup [account]
 
 # mox serve
 
@@ -139,10 +191,31 @@ Quickstart generates configuration files and prints instructions to quickly set
 Quickstart writes configuration files, prints initial admin and account
 passwords, DNS records you should create. If you run it on Linux it writes a
 systemd service file and prints commands to enable and start mox as service.
+With flag -output, quickstart instead writes a docker-compose.yml file for
+running mox with Docker Compose, or a mox-k8s.yaml file with Kubernetes
+manifests: a secret with the generated configuration files, volumes for the
+config and data directories, and a deployment with host networking and
+liveness/readiness probes using "mox healthcheck". With -output cloud-init,
+quickstart writes a cloud-init.yaml file with user-data for a new virtual
+machine, e.g. at a VPS provider: on first boot, it installs mox with "go
+install", writes the generated configuration files and systemd service file,
+starts mox and sets the account passwords. The configuration is for the virtual
+machine, not for the machine quickstart runs on, so flags -hostname and
+-public-ip are required, and the public listener is configured for the public
+IPs. The user-data installs Go from the distribution packages of Debian or
+Ubuntu, newer versions of Go are downloaded as needed.
 
 The user or uid is optional, defaults to "mox", and is the user or uid/gid mox
 will run as after initialization.
 
+Multiple email addresses can be specified to set up multiple domains and
+accounts at once. Each domain gets its own DKIM keys and DNS records. An
+account is created for each localpart, addresses with the same localpart in
+different domains are destinations of the same account. The first address is
+used for the postmaster account, the ACME contact address and as fallback for
+the hostname. Reports about a domain are delivered to the account of the first
+address in that domain.
+
 Quickstart assumes mox will run on the machine you run quickstart on and uses
 its host name and public IPs. On many systems the hostname is not a fully
 qualified domain name, but only the first dns "label", e.g. "mail" in case of
@@ -151,6 +224,16 @@ hostname, and as fallback uses the label plus the domain of the email address
 you specified. Use flag -hostname to explicitly specify the hostname mox will
 run on.
 
+Quickstart finds the IPs to listen on by looking at the network interfaces. On
+machines with only private IPs, e.g. cloud instances behind a NAT, quickstart
+uses the IPs of the host name as the public IPs. With split DNS, the host name
+may resolve to private IPs locally. Use flag -public-ip, once for each public
+IP, to specify the public IPs explicitly. If they are not configured on a
+network interface, the machine is assumed to be behind a NAT: the public
+listener listens on the private IPs, and the public IPs are configured as NATIPs
+in the public listener. A and AAAA records for the host name with the public IPs
+are added to the suggested DNS records.
+
 Mox is by far easiest to operate if you let it listen on port 443 (HTTPS) and
 80 (HTTP). TLS will be fully automatic with ACME with Let's Encrypt.
 
@@ -167,11 +250,32 @@ traffic to your existing backend applications. Look for "WebHandlers:" in the
 output of "mox config describe-domains" and see the output of
 "mox config example webhandlers".
 
-	usage: mox quickstart [-skipdial] [-existing-webserver] [-hostname host] user@domain [user | uid]
+For unattended provisioning, e.g. by configuration management tools, the admin
+and account passwords can be read from files with flags -admin-password-file
+and -account-password-file instead of being generated. A file name "-" reads
+the password from stdin, for at most one of the flags. Trailing newlines are
+removed. With multiple accounts, the account password file must have a line
+with a password for each account, in order of the addresses. With flag -json,
+the instructions are not printed. Instead, a JSON object is written to stdout
+with the hostname, the accounts, the paths of the files written, the DNS records
+to create for each domain, and the passwords that were generated. Errors are
+still written to stderr.
+
+	usage: mox quickstart [-skipdial] [-existing-webserver] [-hostname host] [-public-ip ip ...] [-admin-password-file file] [-account-password-file file] [-output systemd|docker-compose|k8s|cloud-init|none] [-json] user@domain ... [user | uid]
+	  -account-password-file string
+	    	read account password from file instead of generating one, use "-" for stdin
+	  -admin-password-file string
+	    	read admin password from file instead of generating one, use "-" for stdin
 	  -existing-webserver
 	    	use if a webserver is already running, so mox won't listen on port 80 and 443; you'll have to provide tls certificates/keys, and configure the existing webserver as reverse proxy, forwarding requests to mox.
 	  -hostname string
 	    	hostname mox will run on, by default the hostname of the machine quickstart runs on; if specified, the IPs for the hostname are configured for the public listener
+	  -json
+	    	do not print instructions, but write a JSON summary with files written, DNS records and generated passwords to stdout
+	  -output string
+	    	how mox will be run, for generating a service file: systemd, docker-compose, k8s or none; default is systemd on linux when not running in docker
+	  -public-ip value
+	    	public IP of this machine, when behind a NAT or with split DNS; can be repeated
 	  -skipdial
 	    	skip check for outgoing smtp (port 25) connectivity
 
@@ -193,8 +297,8 @@ Set new password an account.
 
 The password is read from stdin. Secrets derived from the password, but not the
 password itself, are stored in the account database. The stored secrets are for
-authentication with: scram-sha-256, scram-sha-1, cram-md5, plain text (bcrypt
-hash).
+authentication with: scram-sha-512, scram-sha-256, scram-sha-1, cram-md5, plain
+text (bcrypt hash).
 
 The parameter is an account name, as configured under Accounts in domains.conf
 and as present in the data/accounts/ directory, not a configured email address
@@ -211,6 +315,22 @@ The password is read from stdin. Its bcrypt hash is stored in a file named
 
 	usage: mox setadminpassword
 
+# mox setadmintotp
+
+Set up two-factor authentication for admin logins to the web interface.
+
+A new secret for time-based one-time passwords (TOTP) is generated, and printed
+as otpauth URI for adding to an authenticator app. A code from the app is read
+from stdin to confirm the setup. The secret is stored in a file named
+"adminpasswd.totp" (next to the admin password file) in the configuration
+directory. Once set up, admin logins require a code from the app.
+
+With -disable, the file is removed and admin logins only require the password.
+
+	usage: mox setadmintotp [-disable]
+	  -disable
+	    	disable two-factor authentication for admin logins
+
 # mox loglevels
 
 Print the log levels, or set a new default log level, or a level for the given package.
@@ -226,6 +346,143 @@ Valid labels: error, info, debug, trace, traceauth, tracedata.
 
 	usage: mox loglevels [level [pkg]]
 
+# mox healthcheck
+
+Check the health of the running mox instance.
+
+Prints a line per check, starting with "ok" or "error". Liveness checks verify
+the listeners are bound and the queue is running. Readiness checks also verify
+all accounts can be opened, ACME certificates do not expire within 7 days, the
+hostname resolves, and that periodic DNS checks of domains, if enabled, found no
+errors.
+
+Exits with status 1 if a check failed, for use as probe in container
+orchestration. The same checks are available over HTTP at /healthz and /readyz
+on the MetricsHTTP listener.
+
+	usage: mox healthcheck
+	  -live
+	    	only run liveness checks, as for /healthz
+
+# mox doctor
+
+Diagnose common problems with the mox installation.
+
+Runs many checks and prints a report with errors first, then warnings, each
+with a suggestion for fixing the problem. Run as root from the mox working
+directory, typically while mox is running. The checks:
+
+  - The config files are valid, and TLS certificate files can be loaded.
+  - Ownership of the config and data directories and their files.
+  - Health of the running mox, as with "mox healthcheck": listeners, queue, opening
+    accounts, ACME certificates and periodic DNS checks. If mox is not running,
+    the account databases are opened directly.
+  - Expiration of static TLS certificates configured in listeners.
+  - DNS records of all domains, as with "mox config dnscheck": MX, SPF, DKIM,
+    DMARC, MTA-STS, TLSRPT, reverse DNS of the IPs, TLS and DANE TLSA records
+    matching the certificates, and autoconfig.
+  - Presence of the public IPs in DNS blocklists, those configured for the public
+    listener and in MonitorDNSBLs, or popular blocklists if none are configured.
+
+The output is meant to be included in support requests. Exits with status 1 if
+errors were found.
+
+	usage: mox doctor
+
+# mox checksend
+
+Simulate delivery of a message to an address, without sending a message.
+
+Follows the same steps as delivery from the queue: MX records are looked up, the
+MTA-STS policy of the recipient domain is fetched, and for each host the IPs and
+DANE TLSA records are looked up. A connection is made to the first host that
+can be reached, the SMTP session is initialized with EHLO and STARTTLS, and the
+TLS certificate is verified. Then MAIL FROM and RCPT TO are sent, to see if the
+recipient would be accepted, and the session is ended with QUIT before sending
+DATA.
+
+A transcript of the SMTP session is printed, along with the DNS and TLS
+details, to find out why messages to a domain are not delivered. The MTA-STS
+policy is always fetched, the queue may use a cached policy instead.
+
+With -all, each host of the recipient domain is checked, instead of stopping at
+the first host that accepts the recipient.
+
+Exits with status 1 if no host accepted the recipient.
+
+	usage: mox checksend [-from address] [-all] address
+	  -all
+	    	check all hosts instead of stopping at the first that accepts the recipient
+	  -from string
+	    	address for MAIL FROM, default postmaster at the hostname of this mox
+
+# mox checkreceive
+
+Send a test message to an echo service and check the reply.
+
+A message is sent from an address of an account of this mox to an external
+address that replies to incoming messages, such as an echo service for
+checking mail server configurations, or an account with an autoresponder on
+another mail server. Echo services typically include the results of their SPF,
+DKIM and DMARC verification of the test message in the reply.
+
+The running mox queues the message, and waits until the reply is delivered to
+the account of the from address. The reply is recognized by a unique token in
+the subject of the test message. The outcome of the delivery attempts is
+printed while waiting. At the end, a scorecard is printed with the results of
+the delivery, the reply, and the SPF, DKIM and DMARC results as reported by
+the remote in the reply, and as verified by mox for the incoming reply.
+
+Run this before pointing real users at a new installation. Exits with status 1
+if not all checks passed.
+
+	usage: mox checkreceive [-timeout duration] from-address echo-address
+	  -timeout duration
+	    	how long to wait for delivery and the reply (default 10m0s)
+
+# mox top
+
+Show live connections, the queue and recent deliveries in a terminal.
+
+The screen is refreshed periodically with the open SMTP and IMAP connections
+per listener, the messages in the outgoing queue ordered by their next delivery
+attempt, and recent message events: deliveries to mailboxes and remote
+servers, rejections, junk filter classifications and failed deliveries. See
+"mox message trace" for the full history of a message.
+
+Keys:
+
+	j, down arrow	select next message in queue
+	k, up arrow	select previous message in queue
+	n		deliver selected message now
+	h		hold selected message, or release it if on hold
+	r		refresh now
+	q		quit
+
+Only works on unix systems, in a terminal.
+
+	usage: mox top [-interval duration]
+	  -interval duration
+	    	time between refreshes (default 2s)
+
+# mox admin reload
+
+Reload the config file mox.conf of the running mox instance.
+
+The config file and domains.conf are parsed and validated first. If they have
+errors, nothing changes and the errors are printed. Otherwise, the changed lines
+are printed, and changes to fields that can take effect while running, such as
+log levels, spam scanning, transports, alerting and tracing, are applied. Log
+levels changed at runtime are reset to those in the config file. Changes to
+other fields, such as Listeners, ACME and DataDir, are listed as requiring a
+restart. Existing connections are not interrupted.
+
+The same reload is done when mox receives a SIGHUP signal.
+
+	usage: mox admin reload
+	  -preview
+	    	only print the changes, don't apply them
+
 # mox queue holdrules list
 
 List hold rules for the delivery queue.
@@ -679,10 +936,10 @@ The retired webhook is printed to stdout as JSON.
 
 Import a maildir into an account.
 
-The mbox/maildir archive is accessed and imported by the running mox process, so
-it must have access to the archive files. The default suggested systemd service
-file isolates mox from most of the file system, with only the "data/" directory
-accessible, so you may want to put the mbox/maildir archive files in a
+The mbox/maildir/eml/pst archive is accessed and imported by the running mox
+process, so it must have access to the archive files. The default suggested
+systemd service file isolates mox from most of the file system, with only the
+"data/" directory accessible, so you may want to put the archive files in a
 directory like "data/import/" to make it available to mox.
 
 By default, messages will train the junk filter based on their flags and, if
@@ -693,7 +950,8 @@ are added to the message metadata, causing later incoming messages from these
 recipients to be accepted, unless other reputation signals prevent that.
 
 Users can also import mailboxes/messages through the account web page by
-uploading a zip or tgz file with mbox and/or maildirs.
+uploading a zip or tgz file with mbox files, maildirs and/or .eml files, or a
+pst file.
 
 Mailbox flags, like "seen", "answered", will be imported. An optional
 dovecot-keywords file can specify additional flags, like Forwarded/Junk/NotJunk.
@@ -706,10 +964,10 @@ Import an mbox into an account.
 
 Using mbox is not recommended, maildir is a better defined format.
 
-The mbox/maildir archive is accessed and imported by the running mox process, so
-it must have access to the archive files. The default suggested systemd service
-file isolates mox from most of the file system, with only the "data/" directory
-accessible, so you may want to put the mbox/maildir archive files in a
+The mbox/maildir/eml/pst archive is accessed and imported by the running mox
+process, so it must have access to the archive files. The default suggested
+systemd service file isolates mox from most of the file system, with only the
+"data/" directory accessible, so you may want to put the archive files in a
 directory like "data/import/" to make it available to mox.
 
 By default, messages will train the junk filter based on their flags and, if
@@ -720,10 +978,109 @@ are added to the message metadata, causing later incoming messages from these
 recipients to be accepted, unless other reputation signals prevent that.
 
 Users can also import mailboxes/messages through the account web page by
-uploading a zip or tgz file with mbox and/or maildirs.
+uploading a zip or tgz file with mbox files, maildirs and/or .eml files, or a
+pst file.
 
 	usage: mox import mbox accountname mailboxname mbox
 
+# mox import eml
+
+Import a directory tree with .eml files into an account.
+
+The mbox/maildir/eml/pst archive is accessed and imported by the running mox
+process, so it must have access to the archive files. The default suggested
+systemd service file isolates mox from most of the file system, with only the
+"data/" directory accessible, so you may want to put the archive files in a
+directory like "data/import/" to make it available to mox.
+
+By default, messages will train the junk filter based on their flags and, if
+"automatic junk flags" configuration is set, based on mailbox naming.
+
+If the destination mailbox is the Sent mailbox, the recipients of the messages
+are added to the message metadata, causing later incoming messages from these
+recipients to be accepted, unless other reputation signals prevent that.
+
+Users can also import mailboxes/messages through the account web page by
+uploading a zip or tgz file with mbox files, maildirs and/or .eml files, or a
+pst file.
+
+The .eml files in the directory are imported into the mailbox. Subdirectories
+are imported as child mailboxes. If a directory has a metadata.json file, as
+written by "mox export eml", it is used to set the flags, keywords and received
+time of messages. Otherwise the received time is taken from the Date header.
+
+	usage: mox import eml accountname mailboxname dir
+
+# mox import pst
+
+Import the mail folders of an Outlook PST file into an account.
+
+The mbox/maildir/eml/pst archive is accessed and imported by the running mox
+process, so it must have access to the archive files. The default suggested
+systemd service file isolates mox from most of the file system, with only the
+"data/" directory accessible, so you may want to put the archive files in a
+directory like "data/import/" to make it available to mox.
+
+By default, messages will train the junk filter based on their flags and, if
+"automatic junk flags" configuration is set, based on mailbox naming.
+
+If the destination mailbox is the Sent mailbox, the recipients of the messages
+are added to the message metadata, causing later incoming messages from these
+recipients to be accepted, unless other reputation signals prevent that.
+
+Users can also import mailboxes/messages through the account web page by
+uploading a zip or tgz file with mbox files, maildirs and/or .eml files, or a
+pst file.
+
+Mail folders are imported as mailboxes with the same names and hierarchy,
+optionally as child mailboxes of the mailbox specified with -prefix. Folders
+with contacts, calendar items, tasks and notes are skipped. Messages are
+converted to regular internet messages, keeping the original headers for
+received messages. The read, replied, forwarded and flagged status of messages
+are imported as flags.
+
+Both ANSI (Outlook 97-2002) and Unicode (Outlook 2003 and later) PST files are
+supported, without encryption or with the default "compressible encryption".
+PST files with "high encryption" cannot be imported.
+
+	usage: mox import pst [-prefix mailbox] accountname file.pst
+	  -prefix string
+	    	import folders as child mailboxes of this mailbox
+
+# mox import imap
+
+Migrate mailboxes and messages from an account at a remote IMAP server.
+
+All mailboxes of the remote account, with their hierarchy, messages, flags,
+keywords and receive times, are copied into the local account. The remote
+account is not modified: mailboxes are only examined and messages are fetched
+without setting the seen flag. The password for the remote account is read from
+stdin.
+
+The progress is stored in the account database for each remote mailbox. If a
+migration is interrupted, running the command again continues where it left
+off. Running the command again after a completed migration adds new messages
+from the remote server, and updates the flags of earlier migrated messages to
+those at the remote server. This can be repeated until the MX records are
+switched to mox and clients are reconfigured. Messages that are removed at the
+remote server after they were migrated are not removed locally.
+
+Without -prefix, special-use mailboxes, such as Sent, Trash and Junk, are
+migrated into the local mailboxes with the same special-use. Virtual mailboxes
+with special-use \All, \Flagged and \Important, as offered by Gmail, are
+skipped.
+
+The remote server is connected to with TLS on port 993, or with -starttls on port
+143. The TLS certificate of the server must be valid for the host name.
+
+Users can also migrate from a remote IMAP server through the account web page.
+
+	usage: mox import imap [-starttls] [-prefix mailbox] accountname host[:port] username
+	  -prefix string
+	    	migrate remote mailboxes as child mailboxes of this local mailbox
+	  -starttls
+	    	connect without tls, then use starttls
+
 # mox export maildir
 
 Export one or all mailboxes from an account in maildir format.
@@ -733,7 +1090,29 @@ database file directly. This may block if a running mox instance also has the
 database open, e.g. for IMAP connections. To export from a running instance, use
 the accounts web page or webmail.
 
-	usage: mox export maildir [-single] dst-dir account-path [mailbox]
+Mailboxes can be selected with -include and -exclude, with patterns as for
+Go's path.Match, e.g. "Archive/20*". A pattern that matches a mailbox also
+matches its child mailboxes. Messages can be selected by received time with
+-after and -before, with dates in YYYY-MM-DD format, in local time. And by flags
+with -flag and -notflag, e.g. '\Seen', '$Junk' or a keyword. These flags can be
+specified multiple times.
+
+If dst-dir is "-", a tar archive is written to stdout instead of files to a
+directory. The archive is streamed while reading the messages.
+
+	usage: mox export maildir [-single] [-include pattern] [-exclude pattern] [-after date] [-before date] [-flag flag] [-notflag flag] dst-dir account-path [mailbox]
+	  -after string
+	    	only export messages received at or after date, in YYYY-MM-DD format
+	  -before string
+	    	only export messages received before date, in YYYY-MM-DD format
+	  -exclude value
+	    	do not export mailboxes matching pattern, can be repeated
+	  -flag value
+	    	only export messages with flag, can be repeated
+	  -include value
+	    	only export mailboxes matching pattern, can be repeated
+	  -notflag value
+	    	only export messages without flag, can be repeated
 	  -single
 	    	export single mailbox, without any children. disabled if mailbox isn't specified.
 
@@ -748,11 +1127,73 @@ database file directly. This may block if a running mox instance also has the
 database open, e.g. for IMAP connections. To export from a running instance, use
 the accounts web page or webmail.
 
+Mailboxes can be selected with -include and -exclude, with patterns as for
+Go's path.Match, e.g. "Archive/20*". A pattern that matches a mailbox also
+matches its child mailboxes. Messages can be selected by received time with
+-after and -before, with dates in YYYY-MM-DD format, in local time. And by flags
+with -flag and -notflag, e.g. '\Seen', '$Junk' or a keyword. These flags can be
+specified multiple times.
+
+If dst-dir is "-", a tar archive is written to stdout instead of files to a
+directory. The archive is streamed while reading the messages.
+
 For mbox export, "mboxrd" is used where message lines starting with the magic
 "From " string are escaped by prepending a >. All ">*From " are escaped,
 otherwise reconstructing the original could lose a ">".
 
-	usage: mox export mbox [-single] dst-dir account-path [mailbox]
+	usage: mox export mbox [-single] [-include pattern] [-exclude pattern] [-after date] [-before date] [-flag flag] [-notflag flag] dst-dir account-path [mailbox]
+	  -after string
+	    	only export messages received at or after date, in YYYY-MM-DD format
+	  -before string
+	    	only export messages received before date, in YYYY-MM-DD format
+	  -exclude value
+	    	do not export mailboxes matching pattern, can be repeated
+	  -flag value
+	    	only export messages with flag, can be repeated
+	  -include value
+	    	only export mailboxes matching pattern, can be repeated
+	  -notflag value
+	    	only export messages without flag, can be repeated
+	  -single
+	    	export single mailbox, without any children. disabled if mailbox isn't specified.
+
+# mox export eml
+
+Export messages from one or all mailboxes in an account as .eml files.
+
+Each mailbox is written as a directory, with a file for each message. The
+message flags, keywords and received times are written to a file metadata.json
+in each directory, and the received time is also set as the file modification
+time. The resulting directory can be imported with "mox import eml".
+
+Export bypasses a running mox instance. It opens the account mailbox/message
+database file directly. This may block if a running mox instance also has the
+database open, e.g. for IMAP connections. To export from a running instance, use
+the accounts web page or webmail.
+
+Mailboxes can be selected with -include and -exclude, with patterns as for
+Go's path.Match, e.g. "Archive/20*". A pattern that matches a mailbox also
+matches its child mailboxes. Messages can be selected by received time with
+-after and -before, with dates in YYYY-MM-DD format, in local time. And by flags
+with -flag and -notflag, e.g. '\Seen', '$Junk' or a keyword. These flags can be
+specified multiple times.
+
+If dst-dir is "-", a tar archive is written to stdout instead of files to a
+directory. The archive is streamed while reading the messages.
+
+	usage: mox export eml [-single] [-include pattern] [-exclude pattern] [-after date] [-before date] [-flag flag] [-notflag flag] dst-dir account-path [mailbox]
+	  -after string
+	    	only export messages received at or after date, in YYYY-MM-DD format
+	  -before string
+	    	only export messages received before date, in YYYY-MM-DD format
+	  -exclude value
+	    	do not export mailboxes matching pattern, can be repeated
+	  -flag value
+	    	only export messages with flag, can be repeated
+	  -include value
+	    	only export mailboxes matching pattern, can be repeated
+	  -notflag value
+	    	only export messages without flag, can be repeated
 	  -single
 	    	export single mailbox, without any children. disabled if mailbox isn't specified.
 
@@ -828,6 +1269,26 @@ are stored, but with a warning.
 A clean successful backup does not print any output by default. Use the
 -verbose flag for details, including timing.
 
+Each backup contains a file "backup-manifest.json", listing all files in the
+backup with their size, and a SHA-256 hash for files other than messages. For
+databases, a hash of each 64KB chunk is included. The manifest is used by "mox
+verifybackup", and for incremental backups.
+
+With -incremental, a backup is made relative to a previous backup. For a
+destination directory, the previous backup must be a backup directory: message
+files present in the previous backup are hardlinked (or copied) from it instead
+of the data directory. With a destination of "-", the backup is written to
+stdout as a tar file, for storing elsewhere without writing to local disk,
+e.g. "mox backup - | aws s3 cp - s3://bucket/mox-full.tar". The previous backup
+of an incremental stream can be a backup directory or a manifest file, e.g.
+written with -manifest. An incremental stream leaves out message files that were
+in the previous backup, and contains only the changed 64KB chunks of databases,
+in files with a ".delta" suffix. To restore, the full backup and each
+incremental backup must be applied in order. The -manifest flag writes a copy of
+the manifest to a file, for keeping next to the data directory as reference for
+the next incremental backup. Log output is not written to stdout when streaming,
+but is available in the mox logs.
+
 To restore a backup, first shut down mox, move away the old data directory and
 move an earlier backed up directory in its place, run "mox verifydata",
 possibly with the "-fix" option, and restart mox. After the restore, you may
@@ -840,7 +1301,11 @@ can change the backup files (e.g. upgrade database files, move away
 unrecognized message files), so you should make a new backup before actually
 upgrading.
 
-	usage: mox backup dest-dir
+	usage: mox backup [-verbose] [-incremental previous] [-manifest file] dest-dir
+	  -incremental string
+	    	previous backup directory or manifest file to make an incremental backup against
+	  -manifest string
+	    	also write manifest of backup to file
 	  -verbose
 	    	print progress
 
@@ -870,6 +1335,338 @@ possibly making them potentially no longer readable by the previous version.
 	  -skip-size-check
 	    	skip the check for message size
 
+# mox verifybackup
+
+Verify the files in a backup against its manifest.
+
+The backup can be a backup directory, a tar file as written by "mox backup -",
+or "-" to read a tar file from stdin, e.g. "aws s3 cp s3://bucket/mox.tar - |
+mox verifybackup -".
+
+The size of each file in the manifest is checked, and the SHA-256 hash for
+files other than messages. For databases, the hash of each chunk is checked,
+including the chunks in database delta files of incremental streamed backups.
+Files in a backup directory that are not in the manifest are reported.
+
+With -deep, only for backup directories, the message files are checked against
+the queue and account databases: each message file must exist, its size must
+match the size in the database, and the message must parse to the same
+structure as stored in the database. Message files that are encrypted at rest
+are only checked for their presence.
+
+For checking the databases themselves, and consistency of the data within, see
+"mox verifydata".
+
+	usage: mox verifybackup [-deep] backup
+	  -deep
+	    	check message files against the queue and account databases
+
+# mox extractbackup
+
+Create a backup directory from streamed backups.
+
+Backups written with "mox backup -" are tar files. An incremental streamed
+backup only contains the changes since the previous backup. Extractbackup
+creates a backup directory from a full backup and zero or more incremental
+backups, applied in order. The result is the backup at the time of the last
+incremental backup given, i.e. a point-in-time restore. Each incremental backup
+must have been made against the previous backup. A tar file can be "-" for
+stdin.
+
+The resulting directory is a regular backup directory, it can be checked with
+"mox verifybackup" and "mox verifydata", and used with "mox restore" or as new
+data directory.
+
+	usage: mox extractbackup dest-dir backup.tar [incremental.tar ...]
+
+# mox restore
+
+Restore messages of an account from a backup into the running mox instance.
+
+The messages in all mailboxes of the account in the backup directory are added
+to the account, into mailboxes with the same name, which are created if needed.
+Other accounts are not affected, and mox does not have to be stopped. The
+account must exist in the configuration.
+
+With -mailbox, only messages of that mailbox and its child mailboxes are
+restored. With -prefix, e.g. "Restored", the mailboxes are restored under that
+mailbox, e.g. "Restored/Inbox", leaving the existing mailboxes as they are.
+
+Messages that are already present in the destination mailbox, with the same
+Message-ID, size and receive time, are skipped, so a restore can be repeated.
+Message flags and keywords are restored. Messages that were removed from a
+mailbox after the backup was made are added again, and messages added after the
+backup are kept.
+
+To restore to a point in time, use the backup made at that time. For backups
+streamed with "mox backup -", first create a backup directory with "mox
+extractbackup". The backup directory is accessed by the running mox process,
+so it must have access to it, e.g. by placing it in the "data/tmp/" directory.
+
+	usage: mox restore [-mailbox name] [-prefix mailbox] backup-dir account
+	  -mailbox string
+	    	only restore messages of this mailbox and its children
+	  -prefix string
+	    	restore mailboxes under this mailbox
+
+# mox fsck
+
+Check accounts for inconsistencies between the database and message files.
+
+Fsck checks all accounts, or only the account given, of the running mox
+instance. Problems are printed, and only fixed when requested with -fix, with a
+comma-separated list of kinds of problems, or "all". The kinds of problems:
+
+	missing: Message file is missing. Fixed by removing the message from its
+		mailbox. If the message was used to train the junk filter, it stays trained.
+	orphan: Message file that does not belong to a message. Fixed by moving the
+		file to the "moved" directory in the data directory.
+	file: Message file cannot be read, e.g. because it is corrupt or encrypted with
+		an unknown key. Cannot be fixed automatically.
+	size: Message size in database does not match size of message file. Fixed by
+		storing the size of the file, and parsing the message again.
+	parsed: Message structure stored in the database does not match the message
+		file. Only checked with -deep, which parses all messages. Fixed by storing
+		the structure of the parsed message.
+	counts: Counts of messages in a mailbox, or the total message size of an
+		account, are wrong. Fixed by recalculating.
+	uidnext: The next UID of a mailbox is not higher than the UIDs of its
+		messages. Fixed by raising the next UID.
+	uidvalidity: The UIDVALIDITY of a mailbox is not lower than the next
+		UIDVALIDITY for the account. Fixed by raising the next UIDVALIDITY.
+
+Accounts are checked with their read lock held, so incoming deliveries for an
+account wait until it is checked. Fixes are made with the write lock held,
+after checking each problem again.
+
+If problems are fixed that involve messages that are in IMAP clients, you may
+want to invalidate their state with "mox bumpuidvalidity account [mailbox]".
+
+For checking files of a data directory when mox is not running, e.g. of a
+backup, see "mox verifydata".
+
+	usage: mox fsck [-deep] [-fix kinds] [account]
+	  -deep
+	    	parse all messages and compare their structure with the structure stored in the database
+	  -fix string
+	    	comma-separated kinds of problems to fix, or "all"
+
+# mox account takeout
+
+Write all data of an account to a gzipped tar file.
+
+The takeout archive has the account configuration with its addresses and
+delivery rulesets (account.json), webmail settings, identities and retention
+rules (settings.json), filter rules (filters.json), contacts (contacts.vcf),
+calendar events (calendar.ics) and all mailboxes and messages in maildir format
+(messages/).
+
+If dst.tgz is "-", the archive is written to stdout. An existing file is not
+overwritten.
+
+Users can download the same archive through the account web page, and admins
+through the admin web page.
+
+	usage: mox account takeout accountname dst.tgz
+
+# mox account delete request
+
+Request deletion of an account, purging it after a grace period.
+
+Logins to the account are disabled immediately on all protocols, and IMAP/SMTP
+connections of the account are closed. Incoming messages for the addresses of
+the account are still accepted. During the grace period, the deletion can be
+canceled with "mox account delete cancel", and the data of the account can be
+exported with "mox account takeout".
+
+After the grace period, the account is purged: it is removed from the
+configuration, its data directory is removed, its messages and webhooks in the
+queue (including retired messages and webhooks kept as delivery history) and its
+suppressions are removed, and references to the account in the audit log are
+redacted.
+
+Users can request deletion of their own account through the account web page.
+
+	usage: mox account delete request [-grace duration] accountname
+	  -grace duration
+	    	period before the account is purged, can be 0 for purging the next time pending deletions are processed, or with "mox account delete purge" (default 720h0m0s)
+
+# mox account delete cancel
+
+Cancel a pending deletion of an account, enabling logins again.
+
+	usage: mox account delete cancel accountname
+
+# mox account delete purge
+
+Purge an account with a pending deletion now, without waiting for the end of
+the grace period.
+
+Deletion of the account must have been requested first, with "mox account delete
+request". If the account is still in use, e.g. by a connection that has not
+closed yet, an error is printed and the purge is tried again when pending
+deletions are processed, at least once an hour.
+
+	usage: mox account delete purge accountname
+
+# mox account delete list
+
+List accounts with pending deletion.
+
+	usage: mox account delete list
+
+# mox usage
+
+List monthly usage of accounts and domains, for reporting and billing.
+
+Usage is recorded per calendar month (UTC), per account and domain:
+
+  - Incoming messages delivered to the account, per recipient, and their total
+    size. The domain is that of the recipient address.
+  - Outgoing messages delivered to remote mail servers, per recipient, and their
+    total size, i.e. outgoing bandwidth. The domain is that of the sender address.
+  - The highest total size of stored messages of the account during the month,
+    sampled hourly. Recorded for the default domain of the account.
+
+With -group, usage is summed per month and account, or per month and domain.
+The same usage is available through the admin API, with method UsageList, and
+in the admin web interface.
+
+	usage: mox usage [-start yyyy-mm] [-end yyyy-mm] [-account account] [-domain domain] [-group account|domain] [-format text|csv|json]
+	  -account string
+	    	only usage of this account
+	  -domain string
+	    	only usage for this domain
+	  -end string
+	    	last month, inclusive
+	  -format string
+	    	output format: text, csv or json (default "text")
+	  -group string
+	    	sum per month and account or per month and domain, instead of per month, account and domain
+	  -start string
+	    	first month, e.g. 2026-01
+
+# mox replication standby
+
+Replicate the data directory of a primary mox to a local data directory.
+
+The primary must have Replication configured in mox.conf, and a listener with
+ReplicationHTTPS enabled. The primary-url is the URL of that endpoint, e.g.
+https://mail.example.org/replication/. The token file must contain the same
+token as configured on the primary.
+
+The standby keeps running. The primary holds requests of the standby until
+something changes, so changes are replicated as they happen. Of databases that
+changed, only the changed blocks are transferred, and the standby verifies the
+resulting copy against a checksum of the consistent database on the primary.
+New message files are copied, and message files that were rewritten on the
+primary, e.g. by "mox compressmessages", are copied again. Synchronizations
+start at most once per interval.
+
+The standby does not need a mox.conf, and must not run "mox serve" on the data
+directory while replicating. Message files encrypted at rest on the primary are
+copied as is, the standby needs the same master key to read them after a
+failover. Deduplicated message files are copied as separate files.
+
+The state of the replication, including the time of the last successful sync,
+is kept in the file replication.json in the data directory. See "mox
+replication status".
+
+Failover, when the primary is lost:
+
+ 1. Stop "mox replication standby".
+
+ 2. Check when the last sync completed with "mox replication status data-dir".
+    Changes made on the primary after that are lost.
+
+ 3. Put the mox.conf and domains.conf of the primary in place, with DataDir
+    pointing to the replicated data directory. The configuration files are not
+    replicated, keep a copy of them on the standby, and update it when the
+    configuration of the primary changes. Adjust IPs in the listeners if needed.
+
+ 4. Run "mox verifydata data-dir", and start mox with "mox serve".
+
+ 5. Point DNS records for the mail host name (A/AAAA) to the standby, or move
+    the IP address of the primary to the standby. If IPs change, update SPF
+    records, and keep in mind the reputation of the new IP for outgoing email.
+
+ 6. When the old primary comes back, do not start it as is: it would deliver
+    messages from its queue again. Set it up as a standby of the new primary
+    with an empty data directory instead.
+
+    usage: mox replication standby [-name name] [-interval duration] -tokenfile file primary-url data-dir
+    -interval duration
+    minimum interval between synchronizations, and delay before retrying after an error (default 1s)
+    -name string
+    name of standby, shown in status on primary; default is the hostname
+    -tokenfile string
+    file with token for authenticating to primary
+
+# mox replication status
+
+Show the status of replication.
+
+Without parameter, the standbys of the running mox instance are listed, with
+the time of their last completed synchronization. Standbys are only known
+after their first request since mox was started.
+
+With a data directory of a standby as parameter, the replication state of that
+standby is printed, including the lag: how long ago the changes it has were
+made on the primary.
+
+	usage: mox replication status [standby-data-dir]
+
+# mox preflight listen
+
+Check network connectivity of this machine before setting up mox.
+
+Checks whether outgoing smtp connections (port 25) can be made, and whether the
+ports of the public listener of mox (25, 465, 587, 993, 443 and 80) can be
+listened on. Ports that are in use, e.g. by an existing mail or web server, or
+that need root privileges, are reported.
+
+It then keeps listening on the ports, and prints a command with a random token
+to run on a machine outside your network, "mox preflight check". That command
+connects to each port, showing whether the ports are reachable from the
+internet, or blocked by a firewall, e.g. of your hosting provider. Connections
+are not handled beyond writing the token. Listening stops when all ports were
+reached, or after the wait duration.
+
+Many hosting providers block incoming or outgoing smtp connections by default.
+If the reverse DNS names of the public IPs of this machine match a known
+provider, a hint for that provider is printed.
+
+Run this command before starting mox, as root, so the ports can be listened on.
+
+	usage: mox preflight listen [-skipdial] [-wait duration]
+	  -skipdial
+	    	skip check for outgoing smtp (port 25) connectivity
+	  -wait duration
+	    	how long to wait for connections from the remote check (default 10m0s)
+
+# mox preflight check
+
+Check whether the ports of a machine running "mox preflight listen" are reachable.
+
+Run this command on a machine outside the network of the machine you are
+setting up, with the host and token printed by "mox preflight listen". The
+command connects to each port and verifies the token. A connection that times
+out is likely dropped by a firewall. A refused connection means nothing is
+listening, or a firewall rejects the connection. A response without the token
+means another service answered, e.g. a proxy or port forward of your router.
+
+Many internet service providers block outgoing connections to port 25 for
+residential connections, so run this command from a machine that can make
+outgoing smtp connections.
+
+Exits with status 1 if a port could not be reached.
+
+	usage: mox preflight check [-ports ports] [-timeout duration] host token
+	  -ports string
+	    	comma-separated ports to check (default "25,465,587,993,443,80")
+	  -timeout duration
+	    	timeout for connecting and reading the token, per port (default 10s)
+
 # mox config test
 
 Parses and validates the configuration files.
@@ -879,6 +1676,23 @@ are printed.
 
 	usage: mox config test
 
+# mox config migrate
+
+Migrate domains.conf to the current schema version.
+
+New mox versions can rename or restructure fields in domains.conf. The file has
+a Version field, and files with an older or missing version are migrated when
+parsed, and written by "mox serve" at startup. This command prints the changes,
+and writes them unless -dryrun is set. Run it with a new mox binary to review
+the changes before upgrading. Comments are kept.
+
+Files with includes, environment variables or file references are not written,
+the changes must be made manually.
+
+	usage: mox config migrate [-dryrun]
+	  -dryrun
+	    	only print the changes, don't write them
+
 # mox config dnscheck
 
 Check the DNS records with the configuration for the domain, and print any errors/warnings.
@@ -935,7 +1749,8 @@ explicitly, see the setaccountpassword command.
 Remove an account and reload the configuration.
 
 Email addresses for this account will also be removed, and incoming email for
-these addresses will be rejected.
+these addresses will be rejected. The data directory of the account is kept, see
+"mox account delete request" for deleting an account including its data.
 
 	usage: mox config account rm account
 
@@ -975,6 +1790,18 @@ rejected.
 
 	usage: mox config domain rm domain
 
+# mox config addresses
+
+List addresses of accounts and aliases for domains, one per line.
+
+The list is meant for a secondary MX, for validating recipients before
+accepting messages for a domain. Serve the list over HTTPS, and configure
+it as AddressesURL for the domain in the SecondaryMX section of mox.conf on
+the secondary MX. Catchall addresses are listed as "@domain". Without
+domains, addresses of all domains are listed.
+
+	usage: mox config addresses [domain ...]
+
 # mox config alias list
 
 List aliases for domain.
@@ -1023,6 +1850,56 @@ Remove addresses from alias.
 
 	usage: mox config alias rmaddr alias@domain rcpt1@domain ...
 
+# mox config apitoken list
+
+List tokens for the admin API.
+
+	usage: mox config apitoken list
+
+# mox config apitoken add
+
+Add a token for the admin API, and print it.
+
+The role determines which methods the token can call:
+
+- readonly: only methods that don't make changes.
+- queue: read-only methods, and managing the outgoing queue.
+- domains: read-only methods, and managing domains, accounts, addresses and aliases.
+- admin: all methods.
+
+The token is only printed once, mox only stores a hash. Pass it to the admin API
+in an "Authorization: Bearer <token>" HTTP header. The admin API must be enabled
+in a listener with AdminAPIHTTP or AdminAPIHTTPS.
+
+	usage: mox config apitoken add [-role role] name
+	  -role string
+	    	role of token: readonly, queue, domains or admin (default "admin")
+
+# mox config apitoken rm
+
+Remove a token for the admin API, it can no longer be used.
+
+	usage: mox config apitoken rm name
+
+# mox config apply
+
+Replace domains.conf of the running mox instance with a desired configuration.
+
+The desired configuration must be complete, with all domains, accounts and
+addresses, e.g. generated from a configuration management system. It can be in
+the format of domains.conf, or a JSON object with the same fields. Specify "-"
+to read it from stdin. The configuration is validated, and the domains,
+accounts, addresses and aliases that are added, changed or removed are printed.
+Unless -dryrun is set, domains.conf is then replaced atomically, and the changes
+take effect immediately. Applying the same configuration again makes no changes.
+
+DKIM private keys referenced by the configuration must already exist. Message
+files of removed accounts are not removed.
+
+	usage: mox config apply [-dryrun] domains.conf
+	  -dryrun
+	    	only validate and print the changes, don't apply them
+
 # mox config describe-sendmail
 
 Describe configuration for mox when invoked as sendmail.
@@ -1296,6 +2173,45 @@ The health of a DNS blocklist can be checked by querying for 127.0.0.1 and
 
 	usage: mox dnsbl checkhealth zone
 
+# mox dnsbl monitor
+
+Print the status of our IPs in DNS blocklists, and the history of changes.
+
+A running mox periodically checks the public IPs it can send from against the
+DNSBLs of the SMTP listeners in mox.conf, and those in MonitorDNSBLs in
+domains.conf: every 3 hours, or sooner when many messages are delivered. Changes
+in listing status are recorded and kept as history. A new listing raises an
+alert, if alerting is configured in mox.conf.
+
+For each listing, guidance for delisting is printed, with the delisting
+procedure of well-known blocklists.
+
+With -check, the IPs are checked immediately instead of printing the status of
+the most recent periodic check.
+
+	usage: mox dnsbl monitor [-check] [-history n]
+	  -check
+	    	check the IPs now
+	  -history int
+	    	maximum number of changes in history to print, 0 for all (default 25)
+
+# mox ech genkey
+
+Generate a new key for Encrypted Client Hello (ECH).
+
+The PEM file written to stdout contains an X25519 private key and the
+ECHConfigList with the public key. The file can be configured in ECHKeyFiles of
+a listener.
+
+The public name is the host name clients connect to in the outer (unencrypted)
+client hello, typically the host name of the listener. Mox must have a valid
+certificate for the name.
+
+Clients only use ECH after finding the ECHConfigList in DNS. The DNS record to
+add is printed to stderr.
+
+	usage: mox ech genkey publicname >ech.$publicname.pem
+
 # mox mtasts lookup
 
 Lookup the MTASTS record and policy for the domain.
@@ -1316,7 +2232,14 @@ Recreate and retrain the junk filter for the account.
 Useful after having made changes to the junk filter configuration, or if the
 implementation has changed.
 
-	usage: mox retrain accountname
+With -backlog, the junk filter is not recreated, but only messages with
+junk/nonjunk flags that changed since they were last trained are retrained. For
+accounts with DelayFlagTraining in the junk filter configuration, this happens
+automatically once a day.
+
+	usage: mox retrain [-backlog] accountname
+	  -backlog
+	    	only retrain messages with changed junk/nonjunk flags
 
 # mox sendmail
 
@@ -1498,6 +2421,18 @@ Parse message, print JSON representation.
 	  -smtputf8
 	    	check if message needs smtputf8
 
+# mox message trace
+
+Print the delivery history of a message.
+
+The id is a Message-ID, with or without <>, or the ID of a message in the
+queue. Events are printed oldest first: receipt over SMTP, matching rulesets,
+junk filter classification, delivery to mailboxes or rejection, queueing, and
+delivery attempts from the queue with the response from the remote server.
+Events are kept for 30 days.
+
+	usage: mox message trace id
+
 # mox reassignthreads
 
 Reassign message threads.
@@ -1531,6 +2466,80 @@ ancestors. If the direct parent already wasn't available while matching, this is
 stored as the message having a "missing link" to its stored ancestors.
 
 	usage: mox reassignthreads [account]
+
+# mox reindex
+
+Rebuild data derived from messages in the account or all accounts.
+
+Steps, executed in this order:
+
+  - parse: parse the message files again, storing the MIME structure that is used
+    when searching and fetching messages.
+  - threads: reset and assign threads for all messages.
+  - counts: recalculate the message counts and sizes of mailboxes and accounts.
+
+Messages are processed in batches while mox is running, so accounts remain
+accessible. Progress is printed and stored in the account database. If a
+reindex is interrupted, e.g. by a restart of mox, running the command again
+resumes the remaining steps, with parsing continuing after the last processed
+message. Use -restart to start over with the given steps.
+
+Mox does not maintain a full-text index, searches read the parsed messages.
+
+	usage: mox reindex [-steps parse,threads,counts] [-restart] [account]
+	  -restart
+	    	start over instead of resuming an interrupted reindex
+	  -steps string
+	    	comma-separated steps to run (default "parse,threads,counts")
+
+# mox upgradeaccounts
+
+Apply pending database upgrades for the account or all accounts.
+
+Accounts are opened by mox when first used, e.g. when a user logs in or a
+message is delivered. Changes to the database schema after an upgrade of mox
+are applied when opening the account, and one-time data upgrades, such as
+assigning threads to all messages, run in the background. For large accounts,
+this can take a while, delaying the first use.
+
+This command opens the accounts one by one in the running mox, and waits for
+the upgrades to complete, printing progress. Run it after upgrading mox, before
+users access their accounts. Accounts with an interrupted "mox reindex" are
+listed.
+
+	usage: mox upgradeaccounts [account]
+
+# mox compressmessages
+
+Compress existing message files in the account or all accounts.
+
+New message files are only compressed if CompressMessages is enabled in mox.conf
+or for the account. This command compresses the message files stored earlier.
+Compressed files are also encrypted if encryption at rest is configured.
+Message files are replaced one by one, in batches, so other access to the
+messages is not blocked.
+
+Statistics about the space saved are printed for each account, also for message
+files that were already compressed.
+
+	usage: mox compressmessages [account]
+
+# mox dedup
+
+Deduplicate existing message files in the account or all accounts.
+
+Identical message files, e.g. of a message delivered to multiple accounts, are
+replaced with hard links to a single file. New message files are only
+deduplicated if DeduplicateMessages is enabled in mox.conf. Encrypted message
+files are not deduplicated. Message files are replaced one by one, in batches,
+so other access to the messages is not blocked. Unused files in the blobs
+directory are removed afterwards.
+
+For each account, the size on disk of its message files is printed, and the
+size attributed to the account, with the size of each shared file divided
+between the messages sharing it.
+
+	usage: mox dedup [account]
 */
 package main
 
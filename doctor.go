@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mjl-/bstore"
+	"github.com/mjl-/sherpa"
+
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/dnsbl"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/store"
+	"github.com/mjl-/mox/webadmin"
+)
+
+// Severity of a doctor finding, in order of priority.
+const (
+	doctorError = iota
+	doctorWarning
+)
+
+// doctorFinding is a problem found by "mox doctor".
+type doctorFinding struct {
+	Severity int
+	Check    string // E.g. "config", "dns example.org".
+	Message  string
+	Fix      string // Suggestion for fixing, optional.
+}
+
+// doctor gathers findings and the checks that passed.
+type doctor struct {
+	findings []doctorFinding
+	passed   []string
+}
+
+func (d *doctor) errorf(check, fix, format string, args ...any) {
+	d.findings = append(d.findings, doctorFinding{doctorError, check, fmt.Sprintf(format, args...), fix})
+}
+
+func (d *doctor) warnf(check, fix, format string, args ...any) {
+	d.findings = append(d.findings, doctorFinding{doctorWarning, check, fmt.Sprintf(format, args...), fix})
+}
+
+// pass registers check as passed if no findings were added since n, the number
+// of findings before the check ran.
+func (d *doctor) pass(check string, n int) {
+	if len(d.findings) == n {
+		d.passed = append(d.passed, check)
+	}
+}
+
+func cmdDoctor(c *cmd) {
+	c.help = `Diagnose common problems with the mox installation.
+
+Runs many checks and prints a report with errors first, then warnings, each
+with a suggestion for fixing the problem. Run as root from the mox working
+directory, typically while mox is running. The checks:
+
+- The config files are valid, and TLS certificate files can be loaded.
+- Ownership of the config and data directories and their files.
+- Health of the running mox, as with "mox healthcheck": listeners, queue, opening
+  accounts, ACME certificates and periodic DNS checks. If mox is not running,
+  the account databases are opened directly.
+- Expiration of static TLS certificates configured in listeners.
+- DNS records of all domains, as with "mox config dnscheck": MX, SPF, DKIM,
+  DMARC, MTA-STS, TLSRPT, reverse DNS of the IPs, TLS and DANE TLSA records
+  matching the certificates, and autoconfig.
+- Presence of the public IPs in DNS blocklists, those configured for the public
+  listener and in MonitorDNSBLs, or popular blocklists if none are configured.
+
+The output is meant to be included in support requests. Exits with status 1 if
+errors were found.
+`
+	args := c.Parse()
+	if len(args) != 0 {
+		c.Usage()
+	}
+
+	d := &doctor{}
+	ctx := context.Background()
+
+	// Config. Other checks need a valid config.
+	_, errs := mox.ParseConfig(ctx, c.log, mox.ConfigStaticPath, true, true, false)
+	for _, err := range errs {
+		d.errorf("config", `Edit the config files, check with "mox config test".`, "%v", err)
+	}
+	if len(errs) > 0 {
+		d.report(os.Stdout)
+		os.Exit(1)
+	}
+	d.pass("config", 0)
+	mustLoadConfig()
+
+	n := len(d.findings)
+	doctorPermissions(d)
+	d.pass("permissions", n)
+
+	n = len(d.findings)
+	doctorHealth(d, c.log)
+	d.pass("health", n)
+
+	n = len(d.findings)
+	doctorCertificates(d)
+	d.pass("certificates", n)
+
+	for _, domain := range mox.Conf.Domains() {
+		n = len(d.findings)
+		doctorDNS(d, domain)
+		d.pass("dns "+domain, n)
+	}
+
+	n = len(d.findings)
+	doctorDNSBL(d, c.log, dns.StrictResolver{})
+	d.pass("blocklists", n)
+
+	d.report(os.Stdout)
+	for _, f := range d.findings {
+		if f.Severity == doctorError {
+			os.Exit(1)
+		}
+	}
+}
+
+// report writes the findings to w, errors first, followed by the passed checks.
+func (d *doctor) report(w io.Writer) {
+	sort.SliceStable(d.findings, func(i, j int) bool {
+		return d.findings[i].Severity < d.findings[j].Severity
+	})
+	for _, f := range d.findings {
+		kind := "error"
+		if f.Severity == doctorWarning {
+			kind = "warning"
+		}
+		fmt.Fprintf(w, "%s: %s: %s\n", kind, f.Check, f.Message)
+		if f.Fix != "" {
+			fmt.Fprintf(w, "\tfix: %s\n", f.Fix)
+		}
+	}
+	if len(d.findings) == 0 {
+		fmt.Fprintln(w, "no problems found")
+	}
+	if len(d.passed) > 0 {
+		fmt.Fprintf(w, "passed: %s\n", strings.Join(d.passed, ", "))
+	}
+}
+
+// doctorHealth runs the health checks through the running mox. If mox is not
+// running, the account databases are opened directly.
+func doctorHealth(d *doctor, log mlog.Log) {
+	conn, err := net.Dial("unix", mox.DataDirPath("ctl"))
+	if err != nil {
+		d.warnf("health", `Start mox, e.g. with "systemctl start mox", and check its logs.`, "mox does not appear to be running, connecting to control socket: %v", err)
+
+		for _, name := range mox.Conf.Accounts() {
+			acc, err := store.OpenAccount(log, name)
+			if err != nil {
+				d.errorf("store", `Check file permissions, and run "mox verifydata" on the data directory or a backup.`, "opening account %s: %v", name, err)
+				continue
+			}
+			err = acc.DB.Read(context.Background(), func(tx *bstore.Tx) error { return nil })
+			cerr := acc.Close()
+			log.Check(cerr, "closing account")
+			if err != nil {
+				d.errorf("store", `Run "mox verifydata" on the data directory or a backup.`, "reading database of account %s: %v", name, err)
+			}
+		}
+		return
+	}
+	conn.Close()
+
+	ctl := xctl()
+	ctl.xwrite("healthcheck")
+	ctl.xwrite("false")
+	ctl.xreadok()
+	ctl.xread() // "healthy" or "unhealthy", we look at the individual checks.
+	var buf bytes.Buffer
+	ctl.xstreamto(&buf)
+
+	fixes := map[string]string{
+		"listeners": `Check the mox logs for errors about listening, e.g. "address in use".`,
+		"queue":     "Check the mox logs for errors about the queue, and restart mox.",
+		"store":     `Check the mox logs, and run "mox verifydata" on a backup.`,
+		"acme":      "Check the mox logs for errors requesting certificates, and that ports 80 or 443 are reachable for the ACME provider.",
+		"dns":       "See the DNS findings for the domains.",
+	}
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if s, ok := strings.CutPrefix(line, "error "); ok {
+			check, msg, _ := strings.Cut(s, ": ")
+			d.errorf("health "+check, fixes[check], "%s", msg)
+		}
+	}
+}
+
+// doctorCertificates checks expiration of static TLS certificates of listeners.
+// Certificates from ACME are checked by the health check.
+func doctorCertificates(d *doctor) {
+	const fix = "Replace the certificate file with a renewed certificate, and reload mox."
+	var names []string
+	for name := range mox.Conf.Static.Listeners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		l := mox.Conf.Static.Listeners[name]
+		if l.TLS == nil {
+			continue
+		}
+		for _, kp := range l.TLS.KeyCerts {
+			p := mox.ConfigDirPath(kp.CertFile)
+			buf, err := os.ReadFile(p)
+			if err != nil {
+				d.errorf("certificates", "", "listener %s: reading certificate file: %v", name, err)
+				continue
+			}
+			block, _ := pem.Decode(buf)
+			if block == nil {
+				d.errorf("certificates", "", "listener %s: no pem block in certificate file %s", name, p)
+				continue
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				d.errorf("certificates", "", "listener %s: parsing certificate %s: %v", name, p, err)
+				continue
+			}
+			if time.Now().After(cert.NotAfter) {
+				d.errorf("certificates", fix, "listener %s: certificate %s expired at %s", name, p, cert.NotAfter.Format(time.RFC3339))
+			} else if time.Until(cert.NotAfter) < 14*24*time.Hour {
+				d.warnf("certificates", fix, "listener %s: certificate %s expires at %s", name, p, cert.NotAfter.Format(time.RFC3339))
+			}
+		}
+	}
+}
+
+// doctorDNS checks the DNS records of a domain, like the admin web interface.
+func doctorDNS(d *doctor, domain string) {
+	defer func() {
+		x := recover()
+		if x == nil {
+			return
+		}
+		err, ok := x.(*sherpa.Error)
+		if !ok {
+			panic(x)
+		}
+		d.errorf("dns "+domain, "", "checking domain: %s", err.Message)
+	}()
+
+	r := webadmin.Admin{}.CheckDomain(context.Background(), domain)
+	doctorDNSResult(d, domain, r)
+}
+
+// doctorDNSResult adds the errors and warnings from the DNS check of a domain as
+// findings.
+func doctorDNSResult(d *doctor, domain string, r webadmin.CheckResult) {
+	check := "dns " + domain
+	fix := fmt.Sprintf(`Compare with the records from "mox config dnsrecords %s".`, domain)
+
+	results := []struct {
+		name string
+		r    webadmin.Result
+		fix  string
+	}{
+		{"DNSSEC", r.DNSSEC.Result, "Enable DNSSEC at your DNS operator, and use a DNSSEC-verifying resolver on this machine."},
+		{"IPRev", r.IPRev.Result, "Configure reverse DNS for your IPs to resolve to the host name, at the owner of the IPs, typically your hosting provider."},
+		{"MX", r.MX.Result, fix},
+		{"TLS", r.TLS.Result, "Check the TLS configuration of the listeners, and the mox logs."},
+		{"DANE", r.DANE.Result, fix},
+		{"SPF", r.SPF.Result, fix},
+		{"DKIM", r.DKIM.Result, fix},
+		{"DMARC", r.DMARC.Result, fix},
+		{"Host TLSRPT", r.HostTLSRPT.Result, fix},
+		{"Domain TLSRPT", r.DomainTLSRPT.Result, fix},
+		{"MTA-STS", r.MTASTS.Result, fix},
+		{"SRV conf", r.SRVConf.Result, fix},
+		{"Autoconf", r.Autoconf.Result, fix},
+		{"Autodiscover", r.Autodiscover.Result, fix},
+	}
+	for _, x := range results {
+		for _, s := range x.r.Errors {
+			d.errorf(check, x.fix, "%s: %s", x.name, s)
+		}
+		for _, s := range x.r.Warnings {
+			d.warnf(check, x.fix, "%s: %s", x.name, s)
+		}
+	}
+}
+
+// doctorDNSBL checks whether the public IPs we send from are in DNS blocklists.
+func doctorDNSBL(d *doctor, log mlog.Log, resolver dns.Resolver) {
+	ctx := context.Background()
+	zones := dnsblMonitorZones()
+	if len(zones) == 0 {
+		zones = []dns.Domain{{ASCII: "sbl.spamhaus.org"}, {ASCII: "bl.spamcop.net"}}
+	}
+
+	ips, err := dnsblMonitorIPs(ctx)
+	if err != nil {
+		d.errorf("blocklists", "", "listing ips: %v", err)
+		return
+	}
+	for _, ip := range ips {
+		for _, zone := range zones {
+			status, expl, err := dnsbl.Lookup(ctx, log.Logger, resolver, zone, ip)
+			if status == dnsbl.StatusFail {
+				d.warnf("blocklists", strings.ReplaceAll(dnsbl.DelistText(zone, ip.String()), "\n", " "), "ip %s is listed in %s: %s", ip, zone, expl)
+			} else if err != nil {
+				d.warnf("blocklists", "", "looking up ip %s in %s: %v", ip, zone, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,255 @@
+//go:build !integration
+
+package main
+
+import (
+	"crypto/ed25519"
+	cryptorand "crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/health"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/store"
+	"github.com/mjl-/mox/webadmin"
+)
+
+func TestDoctor(t *testing.T) {
+	os.RemoveAll("testdata/doctor/data")
+	mox.ConfigStaticPath = filepath.FromSlash("testdata/doctor/mox.conf")
+	mox.ConfigDynamicPath = filepath.FromSlash("testdata/doctor/domains.conf")
+	if errs := mox.LoadConfig(ctxbg, pkglog, true, false); len(errs) > 0 {
+		t.Fatalf("loading mox config: %v", errs)
+	}
+	defer store.Switchboard()()
+	err := os.MkdirAll(mox.DataDirPath("."), 0770)
+	tcheck(t, err, "mkdir data dir")
+
+	type finding struct {
+		Severity int
+		Check    string
+		Message  string // Prefix.
+		Fix      string // Prefix.
+	}
+	// Run fn on a new doctor and compare the findings and passed checks.
+	test := func(fn func(d *doctor), expFindings []finding, expPassed []string) *doctor {
+		t.Helper()
+		d := &doctor{}
+		fn(d)
+		var l []finding
+		for i, f := range d.findings {
+			var exp finding
+			if i < len(expFindings) {
+				exp = expFindings[i]
+			}
+			x := finding{f.Severity, f.Check, f.Message, f.Fix}
+			if exp.Message != "" && strings.HasPrefix(x.Message, exp.Message) {
+				x.Message = exp.Message
+			}
+			if exp.Fix != "" && strings.HasPrefix(x.Fix, exp.Fix) {
+				x.Fix = exp.Fix
+			}
+			l = append(l, x)
+		}
+		if !reflect.DeepEqual(l, expFindings) {
+			t.Fatalf("findings:\n%#v\nexpected:\n%#v", l, expFindings)
+		}
+		if !reflect.DeepEqual(d.passed, expPassed) {
+			t.Fatalf("passed %v, expected %v", d.passed, expPassed)
+		}
+		return d
+	}
+
+	// Report, with errors before warnings, fixes, and passed checks.
+	d := test(func(d *doctor) {
+		n := len(d.findings)
+		d.warnf("health", "Start mox.", "mox not running")
+		d.pass("health", n)
+		n = len(d.findings)
+		d.errorf("dns mox.example", "", "SPF: no record")
+		d.pass("dns mox.example", n)
+		d.pass("blocklists", len(d.findings))
+	}, []finding{
+		{doctorWarning, "health", "mox not running", "Start mox."},
+		{doctorError, "dns mox.example", "SPF: no record", ""},
+	}, []string{"blocklists"})
+	var sb strings.Builder
+	d.report(&sb)
+	expReport := "error: dns mox.example: SPF: no record\nwarning: health: mox not running\n\tfix: Start mox.\npassed: blocklists\n"
+	if s := sb.String(); s != expReport {
+		t.Fatalf("report:\n%s\nexpected:\n%s", s, expReport)
+	}
+	sb.Reset()
+	(&doctor{passed: []string{"config"}}).report(&sb)
+	if s := sb.String(); s != "no problems found\npassed: config\n" {
+		t.Fatalf("report without findings: %q", s)
+	}
+
+	// Permissions, with files owned by the mox user, or not.
+	if runtime.GOOS != "windows" {
+		uid := mox.Conf.Static.UID
+		defer func() {
+			mox.Conf.Static.UID = uid
+		}()
+
+		mox.Conf.Static.UID = uint32(os.Getuid())
+		test(func(d *doctor) { doctorPermissions(d) }, nil, nil)
+
+		mox.Conf.Static.UID = uint32(os.Getuid()) + 1
+		test(func(d *doctor) { doctorPermissions(d) }, []finding{
+			{doctorWarning, "permissions", "testdata/doctor is owned by uid", "Restart mox"},
+			{doctorWarning, "permissions", "testdata/doctor/data is owned by uid", "Restart mox"},
+			{doctorError, "permissions", "files not owned by mox user", "Run: chown -R"},
+			{doctorError, "permissions", "files not owned by mox user 1000: testdata/doctor/data", "Run: chown -R 1000 testdata/doctor/data"},
+		}, nil)
+	}
+
+	// Health without running mox, opening the accounts directly.
+	test(func(d *doctor) { doctorHealth(d, pkglog) }, []finding{
+		{doctorWarning, "health", "mox does not appear to be running", "Start mox"},
+	}, nil)
+
+	// An account database that cannot be opened.
+	indexPath := mox.DataDirPath("accounts/mjl/index.db")
+	indexBuf, err := os.ReadFile(indexPath)
+	tcheck(t, err, "read account database")
+	err = os.WriteFile(indexPath, []byte("bogus"), 0660)
+	tcheck(t, err, "write bogus account database")
+	test(func(d *doctor) { doctorHealth(d, pkglog) }, []finding{
+		{doctorWarning, "health", "mox does not appear to be running", "Start mox"},
+		{doctorError, "store", "opening account mjl", "Check file permissions"},
+	}, nil)
+	err = os.WriteFile(indexPath, indexBuf, 0660)
+	tcheck(t, err, "restore account database")
+
+	// Health through a fake running mox, with failing checks.
+	ln, err := net.Listen("unix", mox.DataDirPath("ctl"))
+	tcheck(t, err, "listen on ctl socket")
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				var stop = struct{}{}
+				ctl := &ctl{conn: conn, x: stop, log: pkglog}
+				defer func() {
+					x := recover()
+					if x != nil && x != stop {
+						panic(x)
+					}
+				}()
+				defer conn.Close()
+				ctl.xwrite("ctlv0")
+				if cmd := ctl.xread(); cmd != "healthcheck" {
+					ctl.xerror("unexpected command " + cmd)
+				}
+				ctl.xread() // Live.
+				ctl.xwriteok()
+				ctl.xwrite("unhealthy")
+				w := ctl.writer()
+				health.Write(w, []health.Result{
+					{Check: "listeners"},
+					{Check: "queue", Error: "queue worker not running"},
+					{Check: "store", Info: "1 accounts"},
+					{Check: "acme", Error: "letsencrypt: certificate for mail.mox.example expires soon"},
+				})
+				w.xclose()
+			}()
+		}
+	}()
+	test(func(d *doctor) { doctorHealth(d, pkglog) }, []finding{
+		{doctorError, "health queue", "queue worker not running", "Check the mox logs for errors about the queue"},
+		{doctorError, "health acme", "letsencrypt: certificate for mail.mox.example expires soon", "Check the mox logs for errors requesting certificates"},
+	}, nil)
+	ln.Close()
+
+	// Static certificates of listeners: expired, expiring soon, valid and bad.
+	dir := t.TempDir()
+	writeCert := func(name string, notAfter time.Time) string {
+		t.Helper()
+		privKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize)) // Fake key, don't use this for real!
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			DNSNames:     []string{"mail.mox.example"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     notAfter,
+		}
+		certBuf, err := x509.CreateCertificate(cryptorand.Reader, template, template, privKey.Public(), privKey)
+		tcheck(t, err, "create certificate")
+		p := filepath.Join(dir, name)
+		err = os.WriteFile(p, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certBuf}), 0660)
+		tcheck(t, err, "write certificate")
+		return p
+	}
+	validPath := writeCert("valid.pem", time.Now().Add(60*24*time.Hour))
+	expiringPath := writeCert("expiring.pem", time.Now().Add(7*24*time.Hour))
+	expiredPath := writeCert("expired.pem", time.Now().Add(-time.Minute))
+	badPath := filepath.Join(dir, "bad.pem")
+	err = os.WriteFile(badPath, []byte("bogus"), 0660)
+	tcheck(t, err, "write bad certificate")
+
+	origListener := mox.Conf.Static.Listeners["public"]
+	defer func() {
+		mox.Conf.Static.Listeners["public"] = origListener
+	}()
+	setCerts := func(paths ...string) {
+		l := origListener
+		l.TLS = &config.TLS{}
+		for _, p := range paths {
+			l.TLS.KeyCerts = append(l.TLS.KeyCerts, config.KeyCert{CertFile: p})
+		}
+		mox.Conf.Static.Listeners["public"] = l
+	}
+	setCerts(validPath)
+	test(func(d *doctor) { doctorCertificates(d) }, nil, nil)
+	setCerts(validPath, expiringPath, expiredPath, badPath, filepath.Join(dir, "absent.pem"))
+	test(func(d *doctor) { doctorCertificates(d) }, []finding{
+		{doctorWarning, "certificates", "listener public: certificate " + expiringPath + " expires at", "Replace the certificate"},
+		{doctorError, "certificates", "listener public: certificate " + expiredPath + " expired at", "Replace the certificate"},
+		{doctorError, "certificates", "listener public: no pem block in certificate file " + badPath, ""},
+		{doctorError, "certificates", "listener public: reading certificate file", ""},
+	}, nil)
+
+	// DNS check results.
+	test(func(d *doctor) { doctorDNSResult(d, "mox.example", webadmin.CheckResult{}) }, nil, nil)
+	var r webadmin.CheckResult
+	r.IPRev.Errors = []string{"no reverse dns for 198.51.100.1"}
+	r.SPF.Errors = []string{"no spf record"}
+	r.DMARC.Warnings = []string{"policy is none"}
+	test(func(d *doctor) { doctorDNSResult(d, "mox.example", r) }, []finding{
+		{doctorError, "dns mox.example", "IPRev: no reverse dns for 198.51.100.1", "Configure reverse DNS"},
+		{doctorError, "dns mox.example", "SPF: no spf record", `Compare with the records from "mox config dnsrecords mox.example".`},
+		{doctorWarning, "dns mox.example", "DMARC: policy is none", `Compare with the records from "mox config dnsrecords mox.example".`},
+	}, nil)
+
+	// Blocklists, with the IP of the public listener and the monitored DNSBLs.
+	resolver := dns.MockResolver{}
+	test(func(d *doctor) { doctorDNSBL(d, pkglog, resolver) }, nil, nil)
+	resolver = dns.MockResolver{
+		A: map[string][]string{
+			"1.100.51.198.dnsbl.example.": {"127.0.0.2"},
+		},
+		TXT: map[string][]string{
+			"1.100.51.198.dnsbl.example.": {"listed for spam"},
+		},
+		Fail: []string{"ip 1.100.51.198.other.example."},
+	}
+	test(func(d *doctor) { doctorDNSBL(d, pkglog, resolver) }, []finding{
+		{doctorWarning, "blocklists", "ip 198.51.100.1 is listed in dnsbl.example: listed for spam", "Before requesting removal of 198.51.100.1 from dnsbl.example"},
+		{doctorWarning, "blocklists", "looking up ip 198.51.100.1 in other.example", ""},
+	}, nil)
+}
@@ -0,0 +1,80 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/mjl-/mox/mox-"
+)
+
+// doctorPermissions checks the ownership of the config and data directories and
+// the files in them, as set by mox on startup, see fixperms.
+func doctorPermissions(d *doctor) {
+	moxuid := mox.Conf.Static.UID
+	configdir := filepath.Dir(mox.ConfigStaticPath)
+	datadir := mox.DataDirPath(".")
+	user := mox.Conf.Static.User
+
+	for _, dir := range []string{configdir, datadir} {
+		fi, err := os.Stat(dir)
+		if err != nil {
+			d.errorf("permissions", "", "stat %s: %v", dir, err)
+			continue
+		}
+		st, ok := fi.Sys().(*syscall.Stat_t)
+		if !ok {
+			return
+		}
+		if st.Uid != moxuid {
+			d.warnf("permissions", "Restart mox, it fixes ownership of the config and data directories on startup.", "%s is owned by uid %d, not by mox user %s (uid %d)", dir, st.Uid, user, moxuid)
+		}
+	}
+
+	// Files created by commands run as root, e.g. editing config files or importing
+	// messages, are not fixed on startup if the directories themselves are fine. We
+	// don't look deep into the data directory, it can have many message files.
+	walk := func(dir string, maxDepth int) {
+		var wrong []string
+		var nwrong int
+		depth0 := strings.Count(filepath.Clean(dir), string(filepath.Separator))
+		filepath.WalkDir(dir, func(path string, de fs.DirEntry, err error) error {
+			if err != nil {
+				d.warnf("permissions", "", "walking %s: %v", path, err)
+				return nil
+			}
+			if de.IsDir() && path != dir && filepath.Clean(path) == filepath.Clean(datadir) {
+				return fs.SkipDir // Checked separately.
+			}
+			if de.IsDir() && strings.Count(filepath.Clean(path), string(filepath.Separator))-depth0 >= maxDepth {
+				return fs.SkipDir
+			}
+			fi, err := de.Info()
+			if err != nil {
+				return nil
+			}
+			st, ok := fi.Sys().(*syscall.Stat_t)
+			if ok && st.Uid != moxuid && de.Type()&fs.ModeSocket == 0 {
+				nwrong++
+				if len(wrong) < 5 {
+					wrong = append(wrong, path)
+				}
+			}
+			return nil
+		})
+		if nwrong > 0 {
+			var more string
+			if nwrong > len(wrong) {
+				more = fmt.Sprintf(", and %d more", nwrong-len(wrong))
+			}
+			d.errorf("permissions", fmt.Sprintf("Run: chown -R %s %s", user, dir), "files not owned by mox user %s: %s%s", user, strings.Join(wrong, ", "), more)
+		}
+	}
+	walk(configdir, 3)
+	walk(datadir, 3)
+}
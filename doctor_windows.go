@@ -0,0 +1,6 @@
+package main
+
+// doctorPermissions does not check file ownership on windows, mox serve is not
+// supported on windows.
+func doctorPermissions(d *doctor) {
+}
@@ -195,10 +195,11 @@ Transports:
 				Username: user@example.com
 				Password: test1234
 				Mechanisms:
-					# Allowed authentication mechanisms. Defaults to SCRAM-SHA-256-PLUS,
-					# SCRAM-SHA-256, SCRAM-SHA-1-PLUS, SCRAM-SHA-1, CRAM-MD5. Not included by default:
-					# PLAIN. Specify the strongest mechanism known to be implemented by the server to
-					# prevent mechanism downgrade attacks. (optional)
+					# Allowed authentication mechanisms. Defaults to SCRAM-SHA-512-PLUS,
+					# SCRAM-SHA-512, SCRAM-SHA-256-PLUS, SCRAM-SHA-256, SCRAM-SHA-1-PLUS, SCRAM-SHA-1,
+					# CRAM-MD5. Not included by default: PLAIN. Specify the strongest mechanism known
+					# to be implemented by the server to prevent mechanism downgrade attacks.
+					# (optional)
 
 					- SCRAM-SHA-256-PLUS
 `
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"archive/tar"
 	"context"
 	"log"
+	"os"
 	"path/filepath"
 	"time"
 
@@ -11,43 +13,98 @@ import (
 	"github.com/mjl-/mox/store"
 )
 
-func cmdExportMaildir(c *cmd) {
-	c.params = "[-single] dst-dir account-path [mailbox]"
-	c.help = `Export one or all mailboxes from an account in maildir format.
+const exportCommonParams = "[-single] [-include pattern] [-exclude pattern] [-after date] [-before date] [-flag flag] [-notflag flag] dst-dir account-path [mailbox]"
 
-Export bypasses a running mox instance. It opens the account mailbox/message
+const exportCommonHelp = `Export bypasses a running mox instance. It opens the account mailbox/message
 database file directly. This may block if a running mox instance also has the
 database open, e.g. for IMAP connections. To export from a running instance, use
 the accounts web page or webmail.
+
+Mailboxes can be selected with -include and -exclude, with patterns as for
+Go's path.Match, e.g. "Archive/20*". A pattern that matches a mailbox also
+matches its child mailboxes. Messages can be selected by received time with
+-after and -before, with dates in YYYY-MM-DD format, in local time. And by flags
+with -flag and -notflag, e.g. '\Seen', '$Junk' or a keyword. These flags can be
+specified multiple times.
+
+If dst-dir is "-", a tar archive is written to stdout instead of files to a
+directory. The archive is streamed while reading the messages.
 `
-	var single bool
-	c.flag.BoolVar(&single, "single", false, "export single mailbox, without any children. disabled if mailbox isn't specified.")
+
+func cmdExportMaildir(c *cmd) {
+	c.params = exportCommonParams
+	c.help = `Export one or all mailboxes from an account in maildir format.
+
+` + exportCommonHelp
+	var opts exportOptions
+	opts.flags(c)
 	args := c.Parse()
-	xcmdExport(false, single, args, c)
+	xcmdExport(store.ExportMaildir, opts, args, c)
 }
 
 func cmdExportMbox(c *cmd) {
-	c.params = "[-single] dst-dir account-path [mailbox]"
+	c.params = exportCommonParams
 	c.help = `Export messages from one or all mailboxes in an account in mbox format.
 
 Using mbox is not recommended. Maildir is a better format.
 
-Export bypasses a running mox instance. It opens the account mailbox/message
-database file directly. This may block if a running mox instance also has the
-database open, e.g. for IMAP connections. To export from a running instance, use
-the accounts web page or webmail.
-
+` + exportCommonHelp + `
 For mbox export, "mboxrd" is used where message lines starting with the magic
 "From " string are escaped by prepending a >. All ">*From " are escaped,
 otherwise reconstructing the original could lose a ">".
 `
-	var single bool
-	c.flag.BoolVar(&single, "single", false, "export single mailbox, without any children. disabled if mailbox isn't specified.")
+	var opts exportOptions
+	opts.flags(c)
+	args := c.Parse()
+	xcmdExport(store.ExportMbox, opts, args, c)
+}
+
+func cmdExportEML(c *cmd) {
+	c.params = exportCommonParams
+	c.help = `Export messages from one or all mailboxes in an account as .eml files.
+
+Each mailbox is written as a directory, with a file for each message. The
+message flags, keywords and received times are written to a file metadata.json
+in each directory, and the received time is also set as the file modification
+time. The resulting directory can be imported with "mox import eml".
+
+` + exportCommonHelp
+	var opts exportOptions
+	opts.flags(c)
 	args := c.Parse()
-	xcmdExport(true, single, args, c)
+	xcmdExport(store.ExportEML, opts, args, c)
 }
 
-func xcmdExport(mbox, single bool, args []string, c *cmd) {
+// exportOptions are the command-line flags for the export commands.
+type exportOptions struct {
+	single        bool
+	filter        store.ExportFilter
+	after, before string
+}
+
+func (o *exportOptions) flags(c *cmd) {
+	c.flag.BoolVar(&o.single, "single", false, "export single mailbox, without any children. disabled if mailbox isn't specified.")
+	c.flag.Func("include", "only export mailboxes matching pattern, can be repeated", func(s string) error {
+		o.filter.Include = append(o.filter.Include, s)
+		return nil
+	})
+	c.flag.Func("exclude", "do not export mailboxes matching pattern, can be repeated", func(s string) error {
+		o.filter.Exclude = append(o.filter.Exclude, s)
+		return nil
+	})
+	c.flag.StringVar(&o.after, "after", "", "only export messages received at or after date, in YYYY-MM-DD format")
+	c.flag.StringVar(&o.before, "before", "", "only export messages received before date, in YYYY-MM-DD format")
+	c.flag.Func("flag", "only export messages with flag, can be repeated", func(s string) error {
+		o.filter.Flags = append(o.filter.Flags, s)
+		return nil
+	})
+	c.flag.Func("notflag", "only export messages without flag, can be repeated", func(s string) error {
+		o.filter.NotFlags = append(o.filter.NotFlags, s)
+		return nil
+	})
+}
+
+func xcmdExport(format store.ExportFormat, opts exportOptions, args []string, c *cmd) {
 	if len(args) != 2 && len(args) != 3 {
 		c.Usage()
 	}
@@ -55,12 +112,26 @@ func xcmdExport(mbox, single bool, args []string, c *cmd) {
 	dst := args[0]
 	accountDir := args[1]
 	var mailbox string
+	single := opts.single
 	if len(args) == 3 {
 		mailbox = args[2]
 	} else {
 		single = false
 	}
 
+	filter := opts.filter
+	var err error
+	if opts.after != "" {
+		filter.After, err = time.ParseInLocation("2006-01-02", opts.after, time.Local)
+		xcheckf(err, "parsing -after date")
+	}
+	if opts.before != "" {
+		filter.Before, err = time.ParseInLocation("2006-01-02", opts.before, time.Local)
+		xcheckf(err, "parsing -before date")
+	}
+	err = filter.Check()
+	xcheckf(err, "checking mailbox patterns")
+
 	dbpath := filepath.Join(accountDir, "index.db")
 	db, err := bstore.Open(context.Background(), dbpath, &bstore.Options{Timeout: 5 * time.Second, Perm: 0660}, store.DBTypes...)
 	xcheckf(err, "open database %q", dbpath)
@@ -69,9 +140,16 @@ func xcmdExport(mbox, single bool, args []string, c *cmd) {
 			log.Printf("closing db after export: %v", err)
 		}
 	}()
+	err = store.UpgradeSealed(context.Background(), c.log, filepath.Base(accountDir), db)
+	xcheckf(err, "upgrading messages in database %q", dbpath)
 
-	a := store.DirArchiver{Dir: dst}
-	err = store.ExportMessages(context.Background(), c.log, db, accountDir, a, !mbox, mailbox, !single)
+	var a store.Archiver
+	if dst == "-" {
+		a = store.TarArchiver{Writer: tar.NewWriter(os.Stdout)}
+	} else {
+		a = store.DirArchiver{Dir: dst}
+	}
+	err = store.ExportMessages(context.Background(), c.log, db, accountDir, a, format, mailbox, !single, filter)
 	xcheckf(err, "exporting messages")
 	err = a.Close()
 	xcheckf(err, "closing archiver")
@@ -0,0 +1,184 @@
+package extauth
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var errBER = errors.New("malformed ber")
+
+// BER classes and constructed bit, for the first byte of a tag.
+const (
+	classUniversal   = 0x00
+	classApplication = 0x40
+	classContext     = 0x80
+	constructed      = 0x20
+)
+
+// Universal tags.
+const (
+	tagBoolean     = 0x01
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagEnumerated  = 0x0a
+	tagSequence    = 0x10 | constructed
+	tagSet         = 0x11 | constructed
+)
+
+// berElem is a decoded BER element, with the raw contents for primitive elements
+// and decoded children for constructed elements.
+type berElem struct {
+	tag      byte
+	data     []byte
+	children []berElem
+}
+
+// berTLV encodes a single element. Only single-byte tags are used by LDAP.
+func berTLV(tag byte, data []byte) []byte {
+	n := len(data)
+	var buf []byte
+	switch {
+	case n < 0x80:
+		buf = []byte{tag, byte(n)}
+	case n < 1<<8:
+		buf = []byte{tag, 0x81, byte(n)}
+	case n < 1<<16:
+		buf = []byte{tag, 0x82, byte(n >> 8), byte(n)}
+	default:
+		buf = []byte{tag, 0x84, byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}
+	}
+	return append(buf, data...)
+}
+
+func berString(tag byte, s string) []byte {
+	return berTLV(tag, []byte(s))
+}
+
+func berInt(tag byte, v int64) []byte {
+	// Minimal two's complement encoding.
+	var buf []byte
+	for {
+		buf = append([]byte{byte(v)}, buf...)
+		if (v < 0x80 && v >= -0x80) || len(buf) == 8 {
+			break
+		}
+		v >>= 8
+	}
+	return berTLV(tag, buf)
+}
+
+func berBool(v bool) []byte {
+	if v {
+		return berTLV(tagBoolean, []byte{0xff})
+	}
+	return berTLV(tagBoolean, []byte{0})
+}
+
+func berSeq(tag byte, elems ...[]byte) []byte {
+	var buf []byte
+	for _, e := range elems {
+		buf = append(buf, e...)
+	}
+	return berTLV(tag, buf)
+}
+
+// berRead reads a single element from r. Elements with contents larger than max
+// bytes are rejected.
+func berRead(r *bufio.Reader, max int) ([]byte, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if tag&0x1f == 0x1f {
+		return nil, fmt.Errorf("%w: multi-byte tags not supported", errBER)
+	}
+	b, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	head := []byte{tag, b}
+	n := int(b)
+	if b&0x80 != 0 {
+		nb := int(b & 0x7f)
+		if nb == 0 || nb > 4 {
+			return nil, fmt.Errorf("%w: unsupported length encoding", errBER)
+		}
+		n = 0
+		for i := 0; i < nb; i++ {
+			c, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			head = append(head, c)
+			n = n<<8 | int(c)
+		}
+	}
+	if n > max {
+		return nil, fmt.Errorf("%w: element of %d bytes too large", errBER, n)
+	}
+	buf := make([]byte, len(head)+n)
+	copy(buf, head)
+	if _, err := io.ReadFull(r, buf[len(head):]); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// berParse parses a single element from buf, returning the remaining bytes.
+func berParse(buf []byte, depth int) (berElem, []byte, error) {
+	if depth > 16 {
+		return berElem{}, nil, fmt.Errorf("%w: nested too deeply", errBER)
+	}
+	if len(buf) < 2 {
+		return berElem{}, nil, fmt.Errorf("%w: truncated", errBER)
+	}
+	e := berElem{tag: buf[0]}
+	if e.tag&0x1f == 0x1f {
+		return berElem{}, nil, fmt.Errorf("%w: multi-byte tags not supported", errBER)
+	}
+	n := int(buf[1])
+	o := 2
+	if n&0x80 != 0 {
+		nb := n & 0x7f
+		if nb == 0 || nb > 4 || len(buf) < o+nb {
+			return berElem{}, nil, fmt.Errorf("%w: bad length", errBER)
+		}
+		n = 0
+		for _, c := range buf[o : o+nb] {
+			n = n<<8 | int(c)
+		}
+		o += nb
+	}
+	if n < 0 || len(buf)-o < n {
+		return berElem{}, nil, fmt.Errorf("%w: truncated", errBER)
+	}
+	e.data = buf[o : o+n]
+	rest := buf[o+n:]
+	if e.tag&constructed != 0 {
+		data := e.data
+		for len(data) > 0 {
+			var c berElem
+			var err error
+			c, data, err = berParse(data, depth+1)
+			if err != nil {
+				return berElem{}, nil, err
+			}
+			e.children = append(e.children, c)
+		}
+	}
+	return e, rest, nil
+}
+
+// int parses the element as integer or enumerated.
+func (e berElem) int() (int64, error) {
+	if len(e.data) == 0 || len(e.data) > 8 {
+		return 0, fmt.Errorf("%w: bad integer length %d", errBER, len(e.data))
+	}
+	v := int64(int8(e.data[0]))
+	for _, c := range e.data[1:] {
+		v = v<<8 | int64(c)
+	}
+	return v, nil
+}
@@ -0,0 +1,58 @@
+// Package extauth verifies account passwords with an external authentication
+// backend, LDAP or PAM, instead of the password hash stored in the account.
+//
+// External authentication is configured per domain. The domain of the login
+// address selects the backend. Only plain text passwords can be verified, so
+// authentication mechanisms like SCRAM and CRAM-MD5 that require secrets derived
+// from a locally stored password cannot be used with external authentication.
+package extauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/smtp"
+)
+
+// ErrPAMUnsupported is returned when PAM is configured but mox was built without
+// support for PAM.
+var ErrPAMUnsupported = errors.New("pam not supported in this build of mox, build with cgo and build tag pam")
+
+// Username returns the username to pass to the backend for a login address. The
+// localpart is used as is, without quoting.
+func Username(auth config.DomainAuth, addr smtp.Address) string {
+	if auth.UsernameEmail {
+		return string(addr.Localpart) + "@" + addr.Domain.Name()
+	}
+	return string(addr.Localpart)
+}
+
+// Verify checks the password for the login address with the configured backend.
+// A false result without error means the credentials are not valid. An error is
+// returned when the backend could not be used, e.g. because the LDAP server is
+// unreachable.
+func Verify(ctx context.Context, log mlog.Log, auth config.DomainAuth, addr smtp.Address, password string) (valid bool, rerr error) {
+	// An LDAP simple bind with an empty password is an unauthenticated bind that
+	// succeeds, and PAM may accept empty passwords. Never accept them.
+	if password == "" {
+		return false, nil
+	}
+
+	username := Username(auth, addr)
+	switch {
+	case auth.LDAP != nil:
+		ctx, cancel := context.WithTimeout(ctx, ldapTimeout)
+		defer cancel()
+		valid, rerr = ldapVerify(ctx, *auth.LDAP, username, password)
+	case auth.PAM != nil:
+		valid, rerr = pamVerify(auth.PAM.ServiceEffective(), username, password)
+	default:
+		return false, fmt.Errorf("no external authentication backend configured")
+	}
+	log.Debugx("external authentication", rerr, slog.String("username", username), slog.Bool("valid", valid))
+	return valid, rerr
+}
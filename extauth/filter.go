@@ -0,0 +1,205 @@
+package extauth
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var errFilter = errors.New("bad ldap filter")
+
+// ldapFilterEscape escapes a value for use in an LDAP search filter, RFC 4515.
+func ldapFilterEscape(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch c {
+		case '*', '(', ')', '\\', 0:
+			fmt.Fprintf(&b, "\\%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// ldapDNEscape escapes a value for use as attribute value in a DN, RFC 4514.
+func ldapDNEscape(s string) string {
+	var b strings.Builder
+	for i, c := range []byte(s) {
+		switch {
+		case c == 0:
+			b.WriteString("\\00")
+		case strings.IndexByte(",+\"\\<>;=", c) >= 0,
+			i == 0 && (c == ' ' || c == '#'),
+			i == len(s)-1 && c == ' ':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// LDAPFilterCheck checks the syntax of a configured search filter, with %s as
+// placeholder for the username.
+func LDAPFilterCheck(filter string) error {
+	_, err := ldapFilterParse(strings.ReplaceAll(filter, "%s", "x"))
+	return err
+}
+
+// ldapFilterParse parses a string search filter, RFC 4515, into its BER encoding
+// for a search request, RFC 4511 section 4.5.1. Extensible matches are not
+// supported.
+func ldapFilterParse(s string) ([]byte, error) {
+	p := filterParser{s: s}
+	buf, err := p.filter(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.o != len(s) {
+		return nil, fmt.Errorf("%w: leftover data %q", errFilter, s[p.o:])
+	}
+	return buf, nil
+}
+
+type filterParser struct {
+	s string
+	o int
+}
+
+func (p *filterParser) take(c byte) bool {
+	if p.o < len(p.s) && p.s[p.o] == c {
+		p.o++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) filter(depth int) ([]byte, error) {
+	if depth > 16 {
+		return nil, fmt.Errorf("%w: nested too deeply", errFilter)
+	}
+	if !p.take('(') {
+		return nil, fmt.Errorf("%w: expected ( at offset %d", errFilter, p.o)
+	}
+	var buf []byte
+	var err error
+	switch {
+	case p.take('&'):
+		buf, err = p.list(classContext|constructed|0, depth)
+	case p.take('|'):
+		buf, err = p.list(classContext|constructed|1, depth)
+	case p.take('!'):
+		var f []byte
+		f, err = p.filter(depth + 1)
+		buf = berTLV(classContext|constructed|2, f)
+	default:
+		buf, err = p.item()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !p.take(')') {
+		return nil, fmt.Errorf("%w: expected ) at offset %d", errFilter, p.o)
+	}
+	return buf, nil
+}
+
+func (p *filterParser) list(tag byte, depth int) ([]byte, error) {
+	var elems [][]byte
+	for p.o < len(p.s) && p.s[p.o] == '(' {
+		f, err := p.filter(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, f)
+	}
+	if len(elems) == 0 {
+		return nil, fmt.Errorf("%w: empty filter list", errFilter)
+	}
+	return berSeq(tag, elems...), nil
+}
+
+func (p *filterParser) item() ([]byte, error) {
+	start := p.o
+	for p.o < len(p.s) && strings.IndexByte("=~<>()", p.s[p.o]) < 0 {
+		p.o++
+	}
+	attr := p.s[start:p.o]
+	if attr == "" {
+		return nil, fmt.Errorf("%w: missing attribute at offset %d", errFilter, start)
+	}
+	var tag byte
+	switch {
+	case p.take('='):
+		tag = classContext | constructed | 3
+	case p.take('~') && p.take('='):
+		tag = classContext | constructed | 8
+	case p.take('>') && p.take('='):
+		tag = classContext | constructed | 5
+	case p.take('<') && p.take('='):
+		tag = classContext | constructed | 6
+	default:
+		return nil, fmt.Errorf("%w: bad filter type at offset %d", errFilter, p.o)
+	}
+
+	// Value, split by unescaped "*" for substring matches.
+	var parts []string
+	var cur []byte
+	for p.o < len(p.s) && p.s[p.o] != ')' {
+		c := p.s[p.o]
+		switch c {
+		case '(':
+			return nil, fmt.Errorf("%w: unescaped ( in value", errFilter)
+		case '*':
+			parts = append(parts, string(cur))
+			cur = nil
+			p.o++
+		case '\\':
+			if p.o+3 > len(p.s) {
+				return nil, fmt.Errorf("%w: truncated escape", errFilter)
+			}
+			x, err := hex.DecodeString(p.s[p.o+1 : p.o+3])
+			if err != nil {
+				return nil, fmt.Errorf("%w: bad escape: %v", errFilter, err)
+			}
+			cur = append(cur, x[0])
+			p.o += 3
+		default:
+			cur = append(cur, c)
+			p.o++
+		}
+	}
+	parts = append(parts, string(cur))
+
+	if len(parts) == 1 {
+		return berSeq(tag, berString(tagOctetString, attr), berString(tagOctetString, parts[0])), nil
+	}
+	if tag != classContext|constructed|3 {
+		return nil, fmt.Errorf("%w: wildcard only allowed in equality match", errFilter)
+	}
+	if len(parts) == 2 && parts[0] == "" && parts[1] == "" {
+		// Present.
+		return berString(classContext|7, attr), nil
+	}
+	var subs [][]byte
+	for i, s := range parts {
+		if s == "" {
+			continue
+		}
+		switch i {
+		case 0:
+			subs = append(subs, berString(classContext|0, s))
+		case len(parts) - 1:
+			subs = append(subs, berString(classContext|2, s))
+		default:
+			subs = append(subs, berString(classContext|1, s))
+		}
+	}
+	if len(subs) == 0 {
+		return nil, fmt.Errorf("%w: empty substring match", errFilter)
+	}
+	return berSeq(classContext|constructed|4, berString(tagOctetString, attr), berSeq(tagSequence, subs...)), nil
+}
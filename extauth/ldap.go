@@ -0,0 +1,294 @@
+package extauth
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mjl-/mox/config"
+)
+
+// LDAP result codes, RFC 4511 appendix A.
+const (
+	ldapSuccess            = 0
+	ldapSizeLimitExceeded  = 4
+	ldapInvalidCredentials = 49
+)
+
+// LDAP protocol operations, as application tags, RFC 4511 section 4.2 and further.
+const (
+	opBindRequest      = classApplication | constructed | 0
+	opBindResponse     = classApplication | constructed | 1
+	opUnbindRequest    = classApplication | 2
+	opSearchRequest    = classApplication | constructed | 3
+	opSearchEntry      = classApplication | constructed | 4
+	opSearchDone       = classApplication | constructed | 5
+	opSearchReference  = classApplication | constructed | 19
+	opExtendedRequest  = classApplication | constructed | 23
+	opExtendedResponse = classApplication | constructed | 24
+)
+
+// Maximum size of a response message we accept.
+const ldapMaxMessage = 1 << 20
+
+// ldapTimeout is the maximum duration of a password verification with LDAP.
+const ldapTimeout = 10 * time.Second
+
+// ldapResultError is an LDAP operation that completed with a non-success result
+// code.
+type ldapResultError struct {
+	Code    int64
+	Message string
+}
+
+func (e ldapResultError) Error() string {
+	return fmt.Sprintf("ldap result code %d: %s", e.Code, e.Message)
+}
+
+// ldapConn is a connection to an LDAP server, RFC 4511. Only the operations
+// needed for verifying passwords are implemented: simple bind, search and
+// StartTLS.
+type ldapConn struct {
+	conn  net.Conn
+	br    *bufio.Reader
+	msgID int64
+}
+
+// ldapDial connects to the LDAP server from the URL, with StartTLS if requested.
+// The deadline from ctx applies to the whole connection.
+func ldapDial(ctx context.Context, c config.LDAPAuth) (*ldapConn, error) {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing url: %v", err)
+	}
+	host := u.Host
+	var useTLS bool
+	switch u.Scheme {
+	case "ldap":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "389")
+		}
+	case "ldaps":
+		useTLS = true
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "636")
+		}
+	default:
+		return nil, fmt.Errorf("unknown scheme %q", u.Scheme)
+	}
+	tlsConfig := &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12}
+
+	var d net.Dialer
+	var conn net.Conn
+	if useTLS {
+		td := tls.Dialer{NetDialer: &d, Config: tlsConfig}
+		conn, err = td.DialContext(ctx, "tcp", host)
+	} else {
+		conn, err = d.DialContext(ctx, "tcp", host)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dial: %v", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	lc := &ldapConn{conn: conn, br: bufio.NewReader(conn)}
+	if c.StartTLS && !useTLS {
+		if err := lc.startTLS(tlsConfig); err != nil {
+			lc.Close()
+			return nil, fmt.Errorf("starttls: %w", err)
+		}
+	}
+	return lc, nil
+}
+
+// Close sends an unbind request and closes the connection.
+func (c *ldapConn) Close() error {
+	c.msgID++
+	_, _ = c.conn.Write(berSeq(tagSequence, berInt(tagInteger, c.msgID), berTLV(opUnbindRequest, nil)))
+	return c.conn.Close()
+}
+
+// request writes a request and returns the protocol operations of the responses
+// until and including a response with one of the final tags.
+func (c *ldapConn) request(op []byte, final ...byte) ([]berElem, error) {
+	c.msgID++
+	msg := berSeq(tagSequence, berInt(tagInteger, c.msgID), op)
+	if _, err := c.conn.Write(msg); err != nil {
+		return nil, fmt.Errorf("write: %v", err)
+	}
+	var l []berElem
+	for {
+		buf, err := berRead(c.br, ldapMaxMessage)
+		if err != nil {
+			return nil, fmt.Errorf("read: %w", err)
+		}
+		e, _, err := berParse(buf, 0)
+		if err != nil {
+			return nil, err
+		}
+		if e.tag != tagSequence || len(e.children) < 2 {
+			return nil, fmt.Errorf("%w: bad ldap message", errBER)
+		}
+		id, err := e.children[0].int()
+		if err != nil {
+			return nil, err
+		}
+		if id == 0 {
+			// Unsolicited notification, e.g. notice of disconnection.
+			return nil, fmt.Errorf("unsolicited notification from server")
+		} else if id != c.msgID {
+			return nil, fmt.Errorf("response for message id %d, expected %d", id, c.msgID)
+		}
+		resp := e.children[1]
+		l = append(l, resp)
+		for _, t := range final {
+			if resp.tag == t {
+				return l, nil
+			}
+		}
+	}
+}
+
+// result checks the LDAPResult in a response, RFC 4511 section 4.1.9.
+func ldapResult(e berElem) error {
+	if len(e.children) < 3 {
+		return fmt.Errorf("%w: bad ldap result", errBER)
+	}
+	code, err := e.children[0].int()
+	if err != nil {
+		return err
+	}
+	if code != ldapSuccess {
+		return ldapResultError{code, string(e.children[2].data)}
+	}
+	return nil
+}
+
+func (c *ldapConn) startTLS(tlsConfig *tls.Config) error {
+	// RFC 4511 section 4.14.
+	op := berSeq(opExtendedRequest, berString(classContext|0, "1.3.6.1.4.1.1466.20037"))
+	l, err := c.request(op, opExtendedResponse)
+	if err != nil {
+		return err
+	}
+	if err := ldapResult(l[len(l)-1]); err != nil {
+		return err
+	}
+	if c.br.Buffered() > 0 {
+		return fmt.Errorf("data after starttls response")
+	}
+	tc := tls.Client(c.conn, tlsConfig)
+	if err := tc.Handshake(); err != nil {
+		return fmt.Errorf("tls handshake: %v", err)
+	}
+	c.conn = tc
+	c.br = bufio.NewReader(tc)
+	return nil
+}
+
+// Bind does a simple bind. An ldapResultError is returned for unsuccessful
+// binds, e.g. with code ldapInvalidCredentials.
+func (c *ldapConn) Bind(dn, password string) error {
+	// RFC 4511 section 4.2.
+	op := berSeq(opBindRequest, berInt(tagInteger, 3), berString(tagOctetString, dn), berString(classContext|0, password))
+	l, err := c.request(op, opBindResponse)
+	if err != nil {
+		return err
+	}
+	return ldapResult(l[len(l)-1])
+}
+
+// SearchDNs searches the subtree of baseDN with filter, returning the DNs of at
+// most sizeLimit matching entries.
+func (c *ldapConn) SearchDNs(baseDN, filter string, sizeLimit int) ([]string, error) {
+	f, err := ldapFilterParse(filter)
+	if err != nil {
+		return nil, err
+	}
+	// RFC 4511 section 4.5.1.
+	op := berSeq(opSearchRequest,
+		berString(tagOctetString, baseDN),
+		berInt(tagEnumerated, 2), // wholeSubtree
+		berInt(tagEnumerated, 0), // neverDerefAliases
+		berInt(tagInteger, int64(sizeLimit)),
+		berInt(tagInteger, 10), // Time limit in seconds.
+		berBool(true),          // Types only.
+		f,
+		berSeq(tagSequence, berString(tagOctetString, "1.1")), // No attributes.
+	)
+	l, err := c.request(op, opSearchDone)
+	if err != nil {
+		return nil, err
+	}
+	var dns []string
+	for _, e := range l {
+		switch e.tag {
+		case opSearchEntry:
+			if len(e.children) < 1 {
+				return nil, fmt.Errorf("%w: bad search result entry", errBER)
+			}
+			dns = append(dns, string(e.children[0].data))
+		case opSearchReference:
+			// We don't follow referrals.
+		case opSearchDone:
+			if err := ldapResult(e); err != nil {
+				return dns, err
+			}
+		default:
+			return nil, fmt.Errorf("unexpected response tag %#x", e.tag)
+		}
+	}
+	return dns, nil
+}
+
+// ldapVerify verifies the password for username with the configured LDAP server.
+func ldapVerify(ctx context.Context, c config.LDAPAuth, username, password string) (bool, error) {
+	conn, err := ldapDial(ctx, c)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	var dn string
+	if c.UserDNTemplate != "" {
+		dn = strings.ReplaceAll(c.UserDNTemplate, "%s", ldapDNEscape(username))
+	} else {
+		if c.BindDN != "" {
+			if err := conn.Bind(c.BindDN, c.BindPassword); err != nil {
+				return false, fmt.Errorf("bind for search: %w", err)
+			}
+		}
+		filter := strings.ReplaceAll(c.Filter, "%s", ldapFilterEscape(username))
+		dns, err := conn.SearchDNs(c.BaseDN, filter, 2)
+		var rerr ldapResultError
+		if err != nil && errors.As(err, &rerr) && rerr.Code == ldapSizeLimitExceeded && len(dns) > 1 {
+			return false, fmt.Errorf("multiple entries match filter")
+		} else if err != nil {
+			return false, fmt.Errorf("search: %w", err)
+		}
+		switch len(dns) {
+		case 0:
+			return false, nil
+		case 1:
+			dn = dns[0]
+		default:
+			return false, fmt.Errorf("multiple entries match filter")
+		}
+	}
+
+	err = conn.Bind(dn, password)
+	var rerr ldapResultError
+	if err != nil && errors.As(err, &rerr) && rerr.Code == ldapInvalidCredentials {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("bind: %w", err)
+	}
+	return true, nil
+}
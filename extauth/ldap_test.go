@@ -0,0 +1,235 @@
+package extauth
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/smtp"
+)
+
+func tcheck(t *testing.T, err error, msg string) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %s", msg, err)
+	}
+}
+
+// fakeLDAP serves binds for a fixed set of entries, and searches with an
+// equality filter on uid.
+type fakeLDAP struct {
+	passwords map[string]string // DN to password.
+	uids      map[string]string // uid to DN.
+}
+
+func (s fakeLDAP) serve(conn net.Conn) {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	write := func(id int64, ops ...[]byte) {
+		for _, op := range ops {
+			conn.Write(berSeq(tagSequence, berInt(tagInteger, id), op))
+		}
+	}
+	result := func(tag byte, code int64) []byte {
+		return berSeq(tag, berInt(tagEnumerated, code), berString(tagOctetString, ""), berString(tagOctetString, ""))
+	}
+	for {
+		buf, err := berRead(br, ldapMaxMessage)
+		if err != nil {
+			return
+		}
+		msg, _, err := berParse(buf, 0)
+		if err != nil {
+			return
+		}
+		id, _ := msg.children[0].int()
+		op := msg.children[1]
+		switch op.tag {
+		case opBindRequest:
+			dn := string(op.children[1].data)
+			pw := string(op.children[2].data)
+			if p, ok := s.passwords[dn]; ok && p == pw {
+				write(id, result(opBindResponse, ldapSuccess))
+			} else {
+				write(id, result(opBindResponse, ldapInvalidCredentials))
+			}
+		case opSearchRequest:
+			f := op.children[6]
+			var ops [][]byte
+			if f.tag == classContext|constructed|3 && string(f.children[0].data) == "uid" {
+				if dn, ok := s.uids[string(f.children[1].data)]; ok {
+					ops = append(ops, berSeq(opSearchEntry, berString(tagOctetString, dn), berSeq(tagSequence)))
+				}
+			}
+			ops = append(ops, result(opSearchDone, ldapSuccess))
+			write(id, ops...)
+		case opUnbindRequest:
+			return
+		default:
+			return
+		}
+	}
+}
+
+func TestLDAP(t *testing.T) {
+	srv := fakeLDAP{
+		passwords: map[string]string{
+			"cn=search,dc=mox,dc=example":          "searchpw",
+			"uid=mjl,ou=people,dc=mox,dc=example":  "testtest",
+			`uid=a\,b,ou=people,dc=mox,dc=example`: "commapw",
+		},
+		uids: map[string]string{
+			"mjl": "uid=mjl,ou=people,dc=mox,dc=example",
+		},
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	tcheck(t, err, "listen")
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go srv.serve(conn)
+		}
+	}()
+
+	log := mlog.New("extauth", nil)
+	url := "ldap://" + ln.Addr().String()
+	addr := func(lp string) smtp.Address {
+		return smtp.NewAddress(smtp.Localpart(lp), dns.Domain{ASCII: "mox.example"})
+	}
+
+	xverify := func(auth config.DomainAuth, lp, password string, expValid bool, expErr bool) {
+		t.Helper()
+		valid, err := Verify(context.Background(), log, auth, addr(lp), password)
+		if (err != nil) != expErr {
+			t.Fatalf("verify %s: got err %v, expected error %v", lp, err, expErr)
+		}
+		if valid != expValid {
+			t.Fatalf("verify %s: got valid %v, expected %v", lp, valid, expValid)
+		}
+	}
+
+	// Direct bind with DN template.
+	dnAuth := config.DomainAuth{LDAP: &config.LDAPAuth{URL: url, UserDNTemplate: "uid=%s,ou=people,dc=mox,dc=example"}}
+	xverify(dnAuth, "mjl", "testtest", true, false)
+	xverify(dnAuth, "mjl", "bad", false, false)
+	xverify(dnAuth, "mjl", "", false, false) // Would be an anonymous bind.
+	xverify(dnAuth, "other", "testtest", false, false)
+	xverify(dnAuth, "a,b", "commapw", true, false)
+
+	// Search for user, with bind for searching.
+	searchAuth := config.DomainAuth{LDAP: &config.LDAPAuth{
+		URL:          url,
+		BindDN:       "cn=search,dc=mox,dc=example",
+		BindPassword: "searchpw",
+		BaseDN:       "ou=people,dc=mox,dc=example",
+		Filter:       "(uid=%s)",
+	}}
+	xverify(searchAuth, "mjl", "testtest", true, false)
+	xverify(searchAuth, "mjl", "bad", false, false)
+	xverify(searchAuth, "unknown", "testtest", false, false)
+
+	// Bad credentials for search are a configuration error.
+	badSearch := *searchAuth.LDAP
+	badSearch.BindPassword = "bad"
+	xverify(config.DomainAuth{LDAP: &badSearch}, "mjl", "testtest", false, true)
+
+	// Full email address as username.
+	emailAuth := dnAuth
+	emailAuth.UsernameEmail = true
+	if s := Username(emailAuth, addr("mjl")); s != "mjl@mox.example" {
+		t.Fatalf("got username %q, expected mjl@mox.example", s)
+	}
+	xverify(emailAuth, "mjl", "testtest", false, false)
+
+	// Unreachable server.
+	unreachable := config.DomainAuth{LDAP: &config.LDAPAuth{URL: "ldap://127.0.0.1:1", UserDNTemplate: "uid=%s"}}
+	xverify(unreachable, "mjl", "testtest", false, true)
+
+	if PAMSupported {
+		return
+	}
+	_, err = Verify(context.Background(), log, config.DomainAuth{PAM: &config.PAMAuth{}}, addr("mjl"), "testtest")
+	if !errors.Is(err, ErrPAMUnsupported) {
+		t.Fatalf("got err %v, expected ErrPAMUnsupported", err)
+	}
+}
+
+func TestLDAPFilter(t *testing.T) {
+	if s := ldapFilterEscape(`a*(b)\` + "\x00"); s != `a\2a\28b\29\5c\00` {
+		t.Fatalf("filter escape: got %q", s)
+	}
+	if s := ldapDNEscape(" #a,b+c "); s != `\ #a\,b\+c\ ` {
+		t.Fatalf("dn escape: got %q", s)
+	}
+
+	attrValue := func(tag byte, attr, value string) []byte {
+		return berSeq(tag, berString(tagOctetString, attr), berString(tagOctetString, value))
+	}
+	good := []struct {
+		filter string
+		exp    []byte
+	}{
+		{"(uid=mjl)", attrValue(0xa3, "uid", "mjl")},
+		{`(cn=a\2ab)`, attrValue(0xa3, "cn", "a*b")},
+		{"(uid=*)", berString(0x87, "uid")},
+		{"(n>=1)", attrValue(0xa5, "n", "1")},
+		{"(n<=1)", attrValue(0xa6, "n", "1")},
+		{"(n~=x)", attrValue(0xa8, "n", "x")},
+		{"(cn=a*b*c)", berSeq(0xa4, berString(tagOctetString, "cn"), berSeq(tagSequence, berString(0x80, "a"), berString(0x81, "b"), berString(0x82, "c")))},
+		{"(cn=*b*)", berSeq(0xa4, berString(tagOctetString, "cn"), berSeq(tagSequence, berString(0x81, "b")))},
+		{"(&(objectClass=person)(!(uid=x))(|(a=1)(b=2)))", berSeq(0xa0,
+			attrValue(0xa3, "objectClass", "person"),
+			berSeq(0xa2, attrValue(0xa3, "uid", "x")),
+			berSeq(0xa1, attrValue(0xa3, "a", "1"), attrValue(0xa3, "b", "2")),
+		)},
+	}
+	for _, g := range good {
+		buf, err := ldapFilterParse(g.filter)
+		tcheck(t, err, "parse filter "+g.filter)
+		if string(buf) != string(g.exp) {
+			t.Fatalf("filter %s: got %x, expected %x", g.filter, buf, g.exp)
+		}
+	}
+
+	for _, s := range []string{"", "uid=x", "(uid=x", "(uid=x))", "(=x)", "(uid)", "(&)", "(n>=a*)", `(uid=\zz)`, "(uid=a(b)", "(cn=**)"} {
+		if _, err := ldapFilterParse(s); !errors.Is(err, errFilter) {
+			t.Fatalf("filter %q: got err %v, expected errFilter", s, err)
+		}
+	}
+	tcheck(t, LDAPFilterCheck("(&(objectClass=user)(sAMAccountName=%s))"), "check filter")
+}
+
+func TestBER(t *testing.T) {
+	for _, v := range []int64{0, 1, 127, 128, 255, 256, -1, -128, -129, 1 << 40} {
+		e, rest, err := berParse(berInt(tagInteger, v), 0)
+		tcheck(t, err, "parse int")
+		if len(rest) != 0 {
+			t.Fatalf("leftover data")
+		}
+		if x, err := e.int(); err != nil || x != v {
+			t.Fatalf("int %d: got %d, err %v", v, x, err)
+		}
+	}
+
+	long := make([]byte, 300)
+	e, _, err := berParse(berSeq(tagSequence, berTLV(tagOctetString, long), berBool(true)), 0)
+	tcheck(t, err, "parse sequence")
+	if len(e.children) != 2 || len(e.children[0].data) != 300 {
+		t.Fatalf("bad sequence %v", e)
+	}
+
+	for _, buf := range [][]byte{{0x30}, {0x30, 0x05, 0x01}, {0x1f, 0x00}, {0x04, 0x85, 0, 0, 0, 0, 1}} {
+		if _, _, err := berParse(buf, 0); !errors.Is(err, errBER) {
+			t.Fatalf("parse %x: got err %v, expected errBER", buf, err)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+//go:build pam && cgo
+
+package extauth
+
+/*
+#cgo LDFLAGS: -lpam
+#include <security/pam_appl.h>
+#include <stdlib.h>
+#include <string.h>
+
+// conv answers password prompts with the password passed as appdata.
+static int conv(int n, const struct pam_message **msg, struct pam_response **resp, void *appdata) {
+	if (n <= 0 || n > PAM_MAX_NUM_MSG) {
+		return PAM_CONV_ERR;
+	}
+	struct pam_response *r = calloc(n, sizeof(struct pam_response));
+	if (r == NULL) {
+		return PAM_BUF_ERR;
+	}
+	for (int i = 0; i < n; i++) {
+		switch (msg[i]->msg_style) {
+		case PAM_PROMPT_ECHO_OFF:
+			r[i].resp = strdup((const char *)appdata);
+			if (r[i].resp == NULL) {
+				goto fail;
+			}
+			break;
+		case PAM_ERROR_MSG:
+		case PAM_TEXT_INFO:
+			break;
+		default:
+			// E.g. a prompt for a username, we have no answer.
+			goto fail;
+		}
+	}
+	*resp = r;
+	return PAM_SUCCESS;
+
+fail:
+	for (int i = 0; i < n; i++) {
+		free(r[i].resp);
+	}
+	free(r);
+	return PAM_CONV_ERR;
+}
+
+static int authenticate(const char *service, const char *user, const char *password) {
+	struct pam_conv c = {conv, (void *)password};
+	pam_handle_t *h = NULL;
+	int r = pam_start(service, user, &c, &h);
+	if (r != PAM_SUCCESS) {
+		return r;
+	}
+	r = pam_authenticate(h, PAM_SILENT | PAM_DISALLOW_NULL_AUTHTOK);
+	if (r == PAM_SUCCESS) {
+		r = pam_acct_mgmt(h, PAM_SILENT | PAM_DISALLOW_NULL_AUTHTOK);
+	}
+	pam_end(h, r);
+	return r;
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// PAMSupported indicates whether this build can verify passwords with PAM.
+const PAMSupported = true
+
+// pamVerify authenticates with PAM and checks the account is valid. PAM calls can
+// block, e.g. while a module delays after a failed attempt.
+func pamVerify(service, username, password string) (bool, error) {
+	cservice := C.CString(service)
+	defer C.free(unsafe.Pointer(cservice))
+	cuser := C.CString(username)
+	defer C.free(unsafe.Pointer(cuser))
+	cpassword := C.CString(password)
+	defer func() {
+		C.memset(unsafe.Pointer(cpassword), 0, C.size_t(len(password)))
+		C.free(unsafe.Pointer(cpassword))
+	}()
+
+	switch r := C.authenticate(cservice, cuser, cpassword); r {
+	case C.PAM_SUCCESS:
+		return true, nil
+	case C.PAM_AUTH_ERR, C.PAM_USER_UNKNOWN, C.PAM_MAXTRIES, C.PAM_CRED_INSUFFICIENT, C.PAM_ACCT_EXPIRED, C.PAM_NEW_AUTHTOK_REQD, C.PAM_PERM_DENIED:
+		return false, nil
+	default:
+		return false, fmt.Errorf("pam error code %d", int(r))
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !(pam && cgo)
+
+package extauth
+
+// PAMSupported indicates whether this build can verify passwords with PAM.
+const PAMSupported = false
+
+func pamVerify(service, username, password string) (bool, error) {
+	return false, ErrPAMUnsupported
+}
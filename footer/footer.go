@@ -0,0 +1,278 @@
+// Package footer adds footers, e.g. legal disclaimers, to the text of messages
+// submitted by accounts.
+//
+// Footers are added to the text/plain and text/html parts that make up the text
+// of a message, not to attachments. For multipart/alternative, all alternatives
+// get the footer. For other multiparts, such as multipart/mixed, only the first
+// part is considered the text. Signed and encrypted messages are left unchanged,
+// modifications would invalidate them. Modified parts are written as UTF-8 with
+// quoted-printable content-transfer-encoding. All other parts and boundaries are
+// copied unchanged.
+package footer
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"os"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/text/encoding/ianaindex"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/store"
+)
+
+var metricFooter = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "mox_footer_total",
+		Help: "Submitted messages considered for a footer, by result.",
+	},
+	[]string{
+		"result", // added, reply, unsupported, error
+	},
+)
+
+// Select returns the footer for messages submitted by an account, with a message
+// From address in a domain. The footer of the account takes precedence. Nil if no
+// footer is configured.
+func Select(acc config.Account, dom config.Domain) *config.Footer {
+	if acc.Footer != nil {
+		return acc.Footer
+	}
+	return dom.Footer
+}
+
+// Apply adds footer f to the message in msgFile. If the footer was added, a new
+// temporary file with the message is returned, along with its size. The caller
+// must close and remove the file. If the footer isn't added, e.g. because the
+// message is a reply and f has SkipReplies set, or because the message has no
+// text parts that can be modified, a nil file is returned without error.
+func Apply(log mlog.Log, f config.Footer, msgFile *os.File) (*os.File, int64, error) {
+	fi, err := msgFile.Stat()
+	if err != nil {
+		metricFooter.WithLabelValues("error").Inc()
+		return nil, 0, fmt.Errorf("stat message file: %v", err)
+	}
+
+	var buf bytes.Buffer
+	added, err := Add(log, f, msgFile, fi.Size(), &buf)
+	if err != nil {
+		metricFooter.WithLabelValues("error").Inc()
+		return nil, 0, err
+	} else if !added {
+		return nil, 0, nil
+	}
+
+	nf, err := store.CreateMessageTemp(log, "footer")
+	if err != nil {
+		metricFooter.WithLabelValues("error").Inc()
+		return nil, 0, fmt.Errorf("creating temporary file for message with footer: %v", err)
+	}
+	if _, err := nf.Write(buf.Bytes()); err != nil {
+		store.CloseRemoveTempFile(log, nf, "message with footer")
+		metricFooter.WithLabelValues("error").Inc()
+		return nil, 0, fmt.Errorf("writing message with footer: %v", err)
+	}
+	return nf, int64(buf.Len()), nil
+}
+
+// Add writes the message in r of size to w, with footer f added. If the footer
+// does not apply to the message, false is returned and nothing is written.
+func Add(log mlog.Log, f config.Footer, r io.ReaderAt, size int64, w io.Writer) (bool, error) {
+	part, err := message.Parse(log.Logger, false, r)
+	if err == nil {
+		err = part.Walk(log.Logger, nil)
+	}
+	if err != nil {
+		// We don't refuse messages we can't parse, just like the rest of submission.
+		log.Debugx("parsing message for adding footer, not adding footer", err)
+		metricFooter.WithLabelValues("unsupported").Inc()
+		return false, nil
+	}
+
+	if f.SkipReplies {
+		h, err := part.Header()
+		if err != nil {
+			log.Debugx("parsing message header for adding footer, not adding footer", err)
+			metricFooter.WithLabelValues("unsupported").Inc()
+			return false, nil
+		}
+		if h.Get("In-Reply-To") != "" || h.Get("References") != "" {
+			metricFooter.WithLabelValues("reply").Inc()
+			return false, nil
+		}
+	}
+
+	parts := textParts(&part, f)
+	if len(parts) == 0 {
+		metricFooter.WithLabelValues("unsupported").Inc()
+		return false, nil
+	}
+
+	// Copy the message, replacing the text parts.
+	var offset int64
+	for _, p := range parts {
+		if _, err := io.Copy(w, io.NewSectionReader(r, offset, p.HeaderOffset-offset)); err != nil {
+			return false, fmt.Errorf("copying message: %v", err)
+		}
+		if err := writePart(r, p, p == &part, f, w); err != nil {
+			return false, fmt.Errorf("writing part with footer: %v", err)
+		}
+		offset = p.EndOffset
+	}
+	if _, err := io.Copy(w, io.NewSectionReader(r, offset, size-offset)); err != nil {
+		return false, fmt.Errorf("copying message: %v", err)
+	}
+	metricFooter.WithLabelValues("added").Inc()
+	return true, nil
+}
+
+// textParts returns the parts that form the text of the message, in order of
+// occurrence, that the footer can be added to.
+func textParts(p *message.Part, f config.Footer) []*message.Part {
+	if p.MediaType == "MULTIPART" {
+		switch p.MediaSubType {
+		case "SIGNED", "ENCRYPTED":
+			return nil
+		case "ALTERNATIVE":
+			var l []*message.Part
+			for i := range p.Parts {
+				l = append(l, textParts(&p.Parts[i], f)...)
+			}
+			return l
+		}
+		if len(p.Parts) == 0 {
+			return nil
+		}
+		return textParts(&p.Parts[0], f)
+	}
+
+	// A missing content-type is treated as text/plain.
+	var isText, isHTML bool
+	switch {
+	case p.MediaType == "" && p.MediaSubType == "":
+		isText = true
+	case p.MediaType == "TEXT" && p.MediaSubType == "PLAIN":
+		isText = true
+	case p.MediaType == "TEXT" && p.MediaSubType == "HTML":
+		isHTML = true
+	}
+	if isText && len(f.Text) == 0 || !isText && !isHTML {
+		return nil
+	}
+	if h, err := p.Header(); err != nil || strings.HasPrefix(strings.ToLower(strings.TrimSpace(h.Get("Content-Disposition"))), "attachment") {
+		return nil
+	}
+	// We can only add text to the part if we know its character set.
+	switch cs := strings.ToLower(p.ContentTypeParams["charset"]); cs {
+	case "", "us-ascii", "utf-8":
+	default:
+		enc, _ := ianaindex.MIME.Encoding(cs)
+		if enc == nil {
+			enc, _ = ianaindex.IANA.Encoding(cs)
+		}
+		if enc == nil {
+			return nil
+		}
+	}
+	return []*message.Part{p}
+}
+
+// writePart writes the header and body of part p to w, with the footer added to
+// the body. The body is written as UTF-8 in quoted-printable.
+func writePart(r io.ReaderAt, p *message.Part, top bool, f config.Footer, w io.Writer) error {
+	var b bytes.Buffer
+
+	// Copy header, except for the fields we replace.
+	hbuf := make([]byte, p.BodyOffset-p.HeaderOffset)
+	if _, err := r.ReadAt(hbuf, p.HeaderOffset); err != nil {
+		return fmt.Errorf("reading header: %v", err)
+	}
+	var skip, haveMIMEVersion bool
+	for _, line := range strings.SplitAfter(string(hbuf), "\n") {
+		if line == "" || line == "\r\n" || line == "\n" {
+			continue
+		}
+		if line[0] != ' ' && line[0] != '\t' {
+			k, _, _ := strings.Cut(line, ":")
+			k = strings.TrimSpace(k)
+			skip = strings.EqualFold(k, "Content-Type") || strings.EqualFold(k, "Content-Transfer-Encoding")
+			haveMIMEVersion = haveMIMEVersion || strings.EqualFold(k, "MIME-Version")
+		}
+		if !skip {
+			b.WriteString(line)
+		}
+	}
+	isHTML := p.MediaSubType == "HTML"
+	ct := "text/plain"
+	if isHTML {
+		ct = "text/html"
+	}
+	params := map[string]string{}
+	for k, v := range p.ContentTypeParams {
+		if k != "charset" {
+			params[k] = v
+		}
+	}
+	params["charset"] = "utf-8"
+	if top && !haveMIMEVersion {
+		b.WriteString("MIME-Version: 1.0\r\n")
+	}
+	fmt.Fprintf(&b, "Content-Type: %s\r\n", mime.FormatMediaType(ct, params))
+	b.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	body, err := io.ReadAll(p.ReaderUTF8OrBinary())
+	if err != nil {
+		return fmt.Errorf("reading body: %v", err)
+	}
+	text := string(body)
+	if isHTML {
+		text = addHTML(text, f)
+	} else {
+		text = strings.TrimRight(text, "\r\n") + "\r\n\r\n" + strings.Join(f.Text, "\r\n") + "\r\n"
+	}
+
+	qpw := quotedprintable.NewWriter(&b)
+	if _, err := qpw.Write([]byte(text)); err != nil {
+		return fmt.Errorf("encoding body: %v", err)
+	}
+	if err := qpw.Close(); err != nil {
+		return fmt.Errorf("encoding body: %v", err)
+	}
+	// For parts in a multipart, the line ending before the boundary is part of the
+	// boundary, and is not included in the part.
+	data := b.Bytes()
+	if !top {
+		data = bytes.TrimSuffix(data, []byte("\r\n"))
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// addHTML returns the HTML document s with the footer inserted before the
+// closing body tag, or at the end if there is none.
+func addHTML(s string, f config.Footer) string {
+	var footer string
+	if len(f.HTML) > 0 {
+		footer = strings.Join(f.HTML, "\r\n")
+	} else {
+		l := make([]string, len(f.Text))
+		for i, line := range f.Text {
+			l[i] = html.EscapeString(line)
+		}
+		footer = "<p>" + strings.Join(l, "<br>\r\n") + "</p>"
+	}
+	footer = "\r\n" + footer + "\r\n"
+	if i := strings.LastIndex(strings.ToLower(s), "</body>"); i >= 0 {
+		return s[:i] + footer + s[i:]
+	}
+	return strings.TrimRight(s, "\r\n") + footer
+}
@@ -0,0 +1,157 @@
+package footer
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/mlog"
+)
+
+func tcheck(t *testing.T, err error, msg string) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %s", msg, err)
+	}
+}
+
+func TestAdd(t *testing.T) {
+	log := mlog.New("footer", nil)
+	f := config.Footer{Text: []string{"Confidential.", "Café & co"}, SkipReplies: true}
+
+	crlf := func(s string) string {
+		return strings.ReplaceAll(s, "\n", "\r\n")
+	}
+
+	add := func(msg string) (string, bool) {
+		t.Helper()
+		msg = crlf(msg)
+		var b bytes.Buffer
+		added, err := Add(log, f, strings.NewReader(msg), int64(len(msg)), &b)
+		tcheck(t, err, "add footer")
+		if !added {
+			return "", false
+		}
+		// Result must be a valid message.
+		p, err := message.Parse(log.Logger, true, bytes.NewReader(b.Bytes()))
+		tcheck(t, err, "parse result")
+		err = p.Walk(log.Logger, nil)
+		tcheck(t, err, "walk result")
+		return b.String(), true
+	}
+
+	decoded := func(msg string, path ...int) string {
+		t.Helper()
+		p, err := message.Parse(log.Logger, true, strings.NewReader(msg))
+		tcheck(t, err, "parse")
+		err = p.Walk(log.Logger, nil)
+		tcheck(t, err, "walk")
+		for _, i := range path {
+			p = p.Parts[i]
+		}
+		buf, err := io.ReadAll(p.ReaderUTF8OrBinary())
+		tcheck(t, err, "read part")
+		return string(buf)
+	}
+
+	// Plain text message without MIME headers.
+	msg, ok := add(`From: <mjl@mox.example>
+Subject: test
+
+hi
+`)
+	if !ok {
+		t.Fatalf("footer not added to plain text message")
+	}
+	if !strings.Contains(msg, "MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n") {
+		t.Fatalf("missing mime headers in %q", msg)
+	}
+	if got, exp := decoded(msg), crlf("hi\n\nConfidential.\nCafé & co\n"); got != exp {
+		t.Fatalf("got text %q, expected %q", got, exp)
+	}
+
+	// Replies are skipped.
+	if _, ok := add(`From: <mjl@mox.example>
+In-Reply-To: <previous@remote.example>
+
+reply
+`); ok {
+		t.Fatalf("footer added to reply")
+	}
+
+	// Alternative with text and html, in mixed with attachment. Latin-1 text is
+	// converted to utf-8.
+	msg, ok = add(`From: <mjl@mox.example>
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary=outer
+
+--outer
+Content-Type: multipart/alternative; boundary=inner
+
+--inner
+Content-Type: text/plain; charset=iso-8859-1; format=flowed
+Content-Transfer-Encoding: quoted-printable
+
+caf=E9
+--inner
+Content-Type: text/html
+
+<html><body><p>hi</p></body></html>
+--inner--
+--outer
+Content-Type: text/plain
+Content-Disposition: attachment; filename=notes.txt
+
+attached
+--outer--
+`)
+	if !ok {
+		t.Fatalf("footer not added to multipart message")
+	}
+	if got, exp := decoded(msg, 0, 0), crlf("café\n\nConfidential.\nCafé & co"); got != exp {
+		t.Fatalf("got text %q, expected %q", got, exp)
+	}
+	if !strings.Contains(msg, "Content-Type: text/plain; charset=utf-8; format=flowed\r\n") {
+		t.Fatalf("content-type params not kept in %q", msg)
+	}
+	if got, exp := decoded(msg, 0, 1), crlf("<html><body><p>hi</p>\n<p>Confidential.<br>\nCafé &amp; co</p>\n</body></html>"); got != exp {
+		t.Fatalf("got html %q, expected %q", got, exp)
+	}
+	if got := decoded(msg, 1); got != "attached" {
+		t.Fatalf("attachment changed, got %q", got)
+	}
+
+	// Signed messages are left alone.
+	if _, ok := add(`From: <mjl@mox.example>
+MIME-Version: 1.0
+Content-Type: multipart/signed; boundary=x; protocol="application/pgp-signature"
+
+--x
+Content-Type: text/plain
+
+signed
+--x
+Content-Type: application/pgp-signature
+
+sig
+--x--
+`); ok {
+		t.Fatalf("footer added to signed message")
+	}
+
+	// HTML footer, and no text footer for text parts.
+	f = config.Footer{HTML: []string{"<p>Confidential.</p>"}}
+	if _, ok := add("From: <mjl@mox.example>\n\nhi\n"); ok {
+		t.Fatalf("footer added to text part without text footer")
+	}
+	msg, ok = add("From: <mjl@mox.example>\nMIME-Version: 1.0\nContent-Type: text/html\n\n<p>hi</p>\n")
+	if !ok {
+		t.Fatalf("html footer not added")
+	}
+	if got, exp := decoded(msg), crlf("<p>hi</p>\n<p>Confidential.</p>\n"); got != exp {
+		t.Fatalf("got html %q, expected %q", got, exp)
+	}
+}
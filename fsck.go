@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/store"
+)
+
+func cmdFsck(c *cmd) {
+	c.params = "[-deep] [-fix kinds] [account]"
+	c.help = `Check accounts for inconsistencies between the database and message files.
+
+Fsck checks all accounts, or only the account given, of the running mox
+instance. Problems are printed, and only fixed when requested with -fix, with a
+comma-separated list of kinds of problems, or "all". The kinds of problems:
+
+	missing: Message file is missing. Fixed by removing the message from its
+		mailbox. If the message was used to train the junk filter, it stays trained.
+	orphan: Message file that does not belong to a message. Fixed by moving the
+		file to the "moved" directory in the data directory.
+	file: Message file cannot be read, e.g. because it is corrupt or encrypted with
+		an unknown key. Cannot be fixed automatically.
+	size: Message size in database does not match size of message file. Fixed by
+		storing the size of the file, and parsing the message again.
+	parsed: Message structure stored in the database does not match the message
+		file. Only checked with -deep, which parses all messages. Fixed by storing
+		the structure of the parsed message.
+	counts: Counts of messages in a mailbox, or the total message size of an
+		account, are wrong. Fixed by recalculating.
+	uidnext: The next UID of a mailbox is not higher than the UIDs of its
+		messages. Fixed by raising the next UID.
+	uidvalidity: The UIDVALIDITY of a mailbox is not lower than the next
+		UIDVALIDITY for the account. Fixed by raising the next UIDVALIDITY.
+
+Accounts are checked with their read lock held, so incoming deliveries for an
+account wait until it is checked. Fixes are made with the write lock held,
+after checking each problem again.
+
+If problems are fixed that involve messages that are in IMAP clients, you may
+want to invalidate their state with "mox bumpuidvalidity account [mailbox]".
+
+For checking files of a data directory when mox is not running, e.g. of a
+backup, see "mox verifydata".
+`
+	var deep bool
+	var fix string
+	c.flag.BoolVar(&deep, "deep", false, "parse all messages and compare their structure with the structure stored in the database")
+	c.flag.StringVar(&fix, "fix", "", "comma-separated kinds of problems to fix, or \"all\"")
+	args := c.Parse()
+	if len(args) > 1 {
+		c.Usage()
+	}
+
+	var kinds []string
+	if fix == "all" {
+		for _, k := range store.FsckKinds {
+			kinds = append(kinds, string(k))
+		}
+	} else if fix != "" {
+		for _, s := range strings.Split(fix, ",") {
+			if !slices.Contains(store.FsckKinds, store.FsckKind(s)) {
+				log.Fatalf("unknown kind of problem %q", s)
+			}
+			kinds = append(kinds, s)
+		}
+	}
+
+	mustLoadConfig()
+	var account string
+	if len(args) == 1 {
+		account = args[0]
+	}
+	ctlcmdFsck(xctl(), account, deep, kinds)
+}
+
+func ctlcmdFsck(ctl *ctl, account string, deep bool, fix []string) {
+	ctl.xwrite("fsck")
+	ctl.xwrite(account)
+	if deep {
+		ctl.xwrite("deep")
+	} else {
+		ctl.xwrite("")
+	}
+	ctl.xwrite(strings.Join(fix, ","))
+	ctl.xreadok()
+	ctl.xstreamto(os.Stdout)
+	ctl.xreadok()
+}
+
+func fsckctl(ctx context.Context, ctl *ctl) {
+	/* protocol:
+	> "fsck"
+	> account or empty for all accounts
+	> "deep" or ""
+	> comma-separated kinds of problems to fix, or ""
+	< "ok" or error
+	< stream
+	< "ok", or error if problems were found that were not fixed
+	*/
+	accountOpt := ctl.xread()
+	opts := store.FsckOptions{Deep: ctl.xread() == "deep"}
+	if s := ctl.xread(); s != "" {
+		for _, k := range strings.Split(s, ",") {
+			opts.Fix = append(opts.Fix, store.FsckKind(k))
+		}
+	}
+
+	accounts := mox.Conf.Accounts()
+	if accountOpt != "" {
+		if !slices.Contains(accounts, accountOpt) {
+			ctl.xerror("unknown account")
+		}
+		accounts = []string{accountOpt}
+	}
+	ctl.xwriteok()
+	w := ctl.writer()
+
+	var unfixed, nerrors int
+	for _, accName := range accounts {
+		acc, err := store.OpenAccount(ctl.log, accName)
+		if err == nil {
+			var problems []store.FsckProblem
+			problems, err = acc.Fsck(ctx, ctl.log, opts)
+			for _, pr := range problems {
+				var fixed string
+				if pr.Fixed {
+					fixed = " (fixed)"
+				} else {
+					unfixed++
+				}
+				_, werr := fmt.Fprintf(w, "%s: %s: %s%s\n", accName, pr.Kind, pr.Text, fixed)
+				ctl.xcheck(werr, "write")
+			}
+			xerr := acc.Close()
+			ctl.log.Check(xerr, "closing account after fsck")
+		}
+		if err != nil {
+			nerrors++
+			_, werr := fmt.Fprintf(w, "%s: error: %v\n", accName, err)
+			ctl.xcheck(werr, "write")
+		}
+	}
+	w.xclose()
+
+	if nerrors > 0 {
+		ctl.xwrite(fmt.Sprintf("errors while checking %d account(s)", nerrors))
+	} else if unfixed > 0 {
+		ctl.xwrite(fmt.Sprintf("%d problem(s) found", unfixed))
+	} else {
+		ctl.xwriteok()
+	}
+}
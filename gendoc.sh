@@ -14,6 +14,20 @@ Many of those commands talk to a running mox instance, through the ctl file in
 the data directory. Specify the configuration file (that holds the path to the
 data directory) through the -config flag or MOXCONF environment variable.
 
+Some commands for managing domains, accounts, addresses, aliases, the queue and
+log levels can also be run against a remote mox instance, through its admin API,
+with the -remote flag, e.g. "mox -remote https://mail.example.com/adminapi/
+queue list". The admin API must be enabled in a listener, and the API token,
+see "mox config apitoken add", must be set in the MOXTOKEN environment
+variable. Only these commands can be run with -remote: config domain add,
+config domain rm, config account add, config account rm, config address add,
+config address rm, config alias list, config alias print, config alias add,
+config alias update, config alias rm, config alias addaddr, config alias rmaddr,
+config apply, setaccountpassword, loglevels, queue list, queue hold, queue
+unhold, queue schedule, queue drop and usage. Other commands, e.g. for backups,
+imports, and the queue hold rules, need local access to the control socket or
+data directory, and fail with -remote.
+
 Commands that don't talk to a running mox instance are often for
 testing/debugging email functionality. For example for parsing an email message,
 or looking up SPF/DKIM/DMARC records.
@@ -50,9 +64,9 @@ Mox uses two config files:
 1. mox.conf, also called the static configuration file.
 2. domains.conf, also called the dynamic configuration file.
 
-The static configuration file is never reloaded during the lifetime of a
-running mox instance. After changes to mox.conf, mox must be restarted for the
-changes to take effect.
+Changes to the static configuration file are applied on SIGHUP, with "mox
+admin reload" or through the admin web interface. Most settings take effect
+immediately, but some, such as Listeners, ACME and DataDir, require a restart.
 
 The dynamic configuration file is reloaded automatically when it changes.
 If the file contains an error after the change, the reload is aborted and the
@@ -76,6 +90,25 @@ The config files are in "sconf" format. Properties of sconf files:
 
 See https://pkg.go.dev/github.com/mjl-/sconf for details.
 
+# Includes, environment variables and secrets
+
+Before parsing, mox processes the config files, so they can be stored in
+version control without secrets, and shared between environments:
+
+- A line "!include path", possibly indented, is replaced by the lines of the
+  file at path, with the indenting of the include line added to each line.
+- "\${NAME}" is replaced with the value of environment variable NAME, which
+  must be set. Use "\$\${" for a literal "\${".
+- A value "!file:path", e.g. "Password: !file:smtp-password", is replaced by
+  the contents of the file at path, without trailing newline. The file must
+  have a single line.
+
+Comment lines are not processed. Relative paths are relative to the directory
+of the file containing the line. Changes to files included from domains.conf are
+noticed when domains.conf itself changes. If domains.conf uses includes,
+environment variables or file references, changes through the admin web
+interface or command-line are refused, the file must be edited instead.
+
 
 # mox.conf
 
@@ -264,12 +264,7 @@ Accounts:
 			RemoteIPMasked2:    "1.2.3.0",
 			RemoteIPMasked3:    "1.2.0.0",
 			EHLODomain:         "other.example",
-			MailFrom:           "other@remote.example",
-			MailFromLocalpart:  smtp.Localpart("other"),
 			MailFromDomain:     "remote.example",
-			RcptToLocalpart:    "test1",
-			RcptToDomain:       "mox.example",
-			MsgFromLocalpart:   "other",
 			MsgFromDomain:      "remote.example",
 			MsgFromOrgDomain:   "remote.example",
 			EHLOValidated:      true,
@@ -280,6 +275,13 @@ Accounts:
 			MsgFromValidation:  store.ValidationStrict,
 			DKIMDomains:        []string{"other.example"},
 			Size:               int64(len(msg)),
+			Sealed: store.MessageSealed{
+				MailFrom:          "other@remote.example",
+				MailFromLocalpart: smtp.Localpart("other"),
+				RcptToLocalpart:   "test1",
+				RcptToDomain:      "mox.example",
+				MsgFromLocalpart:  "other",
+			},
 		}
 		mf := tempfile()
 		xcheckf(err, "creating temp file for delivery")
@@ -324,12 +326,7 @@ Accounts:
 			RemoteIPMasked2:    "::",
 			RemoteIPMasked3:    "::",
 			EHLODomain:         "other.example",
-			MailFrom:           "other@remote.example",
-			MailFromLocalpart:  smtp.Localpart("other"),
 			MailFromDomain:     "remote.example",
-			RcptToLocalpart:    "☹",
-			RcptToDomain:       "☺.example",
-			MsgFromLocalpart:   "other",
 			MsgFromDomain:      "remote.example",
 			MsgFromOrgDomain:   "remote.example",
 			EHLOValidated:      true,
@@ -340,6 +337,13 @@ Accounts:
 			MsgFromValidation:  store.ValidationStrict,
 			DKIMDomains:        []string{"other.example"},
 			Size:               int64(len(msg0)),
+			Sealed: store.MessageSealed{
+				MailFrom:          "other@remote.example",
+				MailFromLocalpart: smtp.Localpart("other"),
+				RcptToLocalpart:   "☹",
+				RcptToDomain:      "☺.example",
+				MsgFromLocalpart:  "other",
+			},
 		}
 		mf0 := tempfile()
 		xcheckf(err, "creating temp file for delivery")
@@ -370,7 +374,7 @@ Accounts:
 			MailboxDestinedID: sent.ID,
 			Flags:             store.Flags{Seen: true, Junk: true},
 			Size:              int64(len(prefix1) + len(msg1)),
-			MsgPrefix:         []byte(prefix1),
+			Sealed:            store.MessageSealed{MsgPrefix: []byte(prefix1)},
 		}
 		mf1 := tempfile()
 		xcheckf(err, "creating temp file for delivery")
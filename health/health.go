@@ -0,0 +1,216 @@
+// Package health checks whether mox is running properly, for liveness and
+// readiness probes of container orchestration and for external monitoring.
+//
+// Liveness checks verify the listeners are bound and the queue is delivering.
+// Readiness checks additionally verify the accounts can be opened, ACME
+// certificates are not close to expiring, and DNS self-checks pass.
+package health
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/store"
+	"github.com/mjl-/mox/webadmin"
+)
+
+var pkglog = mlog.New("health", nil)
+
+// Resolver for the DNS self-check, can be replaced for tests.
+var resolver dns.Resolver = dns.StrictResolver{Pkg: "health"}
+
+// Timeout for each check.
+var checkTimeout = 5 * time.Second
+
+// An ACME certificate that expires within this period fails the readiness check.
+// Certificates are normally renewed 30 days before expiration.
+const certExpiryMin = 7 * 24 * time.Hour
+
+// Result of a single check.
+type Result struct {
+	Check string // E.g. "listeners", "queue", "store", "acme", "dns".
+	Error string // Empty if the check passed.
+	Info  string // Optional details, e.g. number of accounts checked.
+}
+
+// OK returns whether all checks passed.
+func OK(l []Result) bool {
+	for _, r := range l {
+		if r.Error != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Write writes a line per check to w, "ok" or "error" followed by the name of
+// the check and details.
+func Write(w io.Writer, l []Result) error {
+	for _, r := range l {
+		var s string
+		if r.Error == "" {
+			s = "ok " + r.Check
+			if r.Info != "" {
+				s += ": " + r.Info
+			}
+		} else {
+			s = "error " + r.Check + ": " + r.Error
+		}
+		if _, err := fmt.Fprintln(w, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check runs the liveness checks, and also the readiness checks if ready is set.
+func Check(ctx context.Context, log mlog.Log, ready bool) []Result {
+	type check struct {
+		name string
+		fn   func(ctx context.Context, log mlog.Log) (info string, err error)
+	}
+	checks := []check{
+		{"listeners", checkListeners},
+		{"queue", checkQueue},
+	}
+	if ready {
+		checks = append(checks,
+			check{"store", checkStore},
+			check{"acme", checkACME},
+			check{"dns", checkDNS},
+		)
+	}
+
+	var l []Result
+	for _, c := range checks {
+		cctx, cancel := context.WithTimeout(ctx, checkTimeout)
+		info, err := c.fn(cctx, log)
+		cancel()
+		r := Result{Check: c.name, Info: info}
+		if err != nil {
+			r.Error = err.Error()
+			log.Infox("health check failed", err, slog.String("check", c.name))
+		}
+		l = append(l, r)
+	}
+	return l
+}
+
+func checkListeners(ctx context.Context, log mlog.Log) (string, error) {
+	if !mox.Started.Load() {
+		return "", fmt.Errorf("still starting up")
+	}
+	if l := mox.ListenersPending(); len(l) > 0 {
+		return "", fmt.Errorf("not listening on %s", strings.Join(l, ", "))
+	}
+	return "", nil
+}
+
+func checkQueue(ctx context.Context, log mlog.Log) (string, error) {
+	return "", queue.WorkerRunning(ctx)
+}
+
+// checkStore opens all accounts and starts a read-only transaction on their
+// database.
+func checkStore(ctx context.Context, log mlog.Log) (string, error) {
+	names := mox.Conf.Accounts()
+	for _, name := range names {
+		acc, err := store.OpenAccount(log, name)
+		if err != nil {
+			return "", fmt.Errorf("open account %s: %v", name, err)
+		}
+		err = acc.DB.Read(ctx, func(tx *bstore.Tx) error { return nil })
+		cerr := acc.Close()
+		log.Check(cerr, "closing account after health check")
+		if err != nil {
+			return "", fmt.Errorf("reading database of account %s: %v", name, err)
+		}
+	}
+	return fmt.Sprintf("%d accounts", len(names)), nil
+}
+
+// checkACME verifies that certificates obtained through ACME are not about to
+// expire. Certificates not yet requested are skipped, they are requested on first
+// use.
+func checkACME(ctx context.Context, log mlog.Log) (string, error) {
+	var n int
+	var errs []string
+	for name, acme := range mox.Conf.Static.ACME {
+		if acme.Manager == nil {
+			continue
+		}
+		for _, host := range acme.Manager.Hostnames() {
+			exp, err := acme.Manager.CertExpiration(ctx, host)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: certificate for %s: %v", name, host, err))
+			} else if exp.IsZero() {
+				continue
+			} else if time.Until(exp) < certExpiryMin {
+				errs = append(errs, fmt.Sprintf("%s: certificate for %s expires at %s", name, host, exp.Format(time.RFC3339)))
+			}
+			n++
+		}
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return "", fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return fmt.Sprintf("%d certificates", n), nil
+}
+
+// checkDNS verifies the hostname of the mail server resolves, and that the most
+// recent periodic DNS checks of domains, if enabled, found no errors.
+func checkDNS(ctx context.Context, log mlog.Log) (string, error) {
+	host := mox.Conf.Static.HostnameDomain
+	ips, _, err := resolver.LookupIP(ctx, "ip", host.ASCII+".")
+	if err != nil {
+		return "", fmt.Errorf("looking up ips of hostname %s: %v", host, err)
+	} else if len(ips) == 0 {
+		return "", fmt.Errorf("no ips for hostname %s", host)
+	}
+
+	domainErrors := webadmin.DNSCheckErrors()
+	if len(domainErrors) > 0 {
+		var l []string
+		for d, n := range domainErrors {
+			l = append(l, fmt.Sprintf("%s (%d)", d, n))
+		}
+		sort.Strings(l)
+		return "", fmt.Errorf("dns check errors for %s", strings.Join(l, ", "))
+	}
+	return "", nil
+}
+
+// Handler returns an HTTP handler responding with the results of the checks,
+// with status 200 if all checks passed and 503 otherwise. If ready is set, the
+// readiness checks are included, as for /readyz. Otherwise only the liveness
+// checks are run, as for /healthz.
+func Handler(ready bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "HEAD" {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		log := pkglog.WithContext(r.Context())
+		l := Check(r.Context(), log, ready)
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		if !OK(l) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		err := Write(w, l)
+		log.Check(err, "writing health check response")
+	})
+}
@@ -0,0 +1,96 @@
+package health
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/store"
+)
+
+var ctxbg = context.Background()
+
+func tcheck(t *testing.T, err error, msg string) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %s", msg, err)
+	}
+}
+
+func tcompare(t *testing.T, got, exp any) {
+	t.Helper()
+	if got != exp {
+		t.Fatalf("got %v, expected %v", got, exp)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	log := mlog.New("health", nil)
+	os.RemoveAll("../testdata/health/data")
+	mox.Context = ctxbg
+	mox.Shutdown, mox.ShutdownCancel = context.WithCancel(ctxbg)
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/health/mox.conf")
+	mox.MustLoadConfig(true, false)
+	defer store.Switchboard()()
+
+	resolver = dns.MockResolver{
+		A: map[string][]string{"mox.example.": {"127.0.0.1"}},
+	}
+
+	// Not started yet.
+	l := Check(ctxbg, log, false)
+	tcompare(t, OK(l), false)
+	tcompare(t, len(l), 2)
+	tcompare(t, l[0].Error, "still starting up")
+	tcompare(t, l[1].Error, "queue worker not started")
+
+	done := make(chan struct{}, 4)
+	err := queue.Start(resolver, done)
+	tcheck(t, err, "queue start")
+	defer func() {
+		mox.ShutdownCancel()
+		// Wait for message and hooks deliverers and cleaners.
+		<-done
+		<-done
+		<-done
+		<-done
+		mox.Shutdown, mox.ShutdownCancel = context.WithCancel(ctxbg)
+		queue.Shutdown()
+	}()
+	mox.Started.Store(true)
+	defer mox.Started.Store(false)
+
+	l = Check(ctxbg, log, true)
+	tcompare(t, len(l), 5)
+	for _, r := range l {
+		tcompare(t, r.Error, "")
+	}
+
+	get := func(path string, ready bool, expCode int) string {
+		t.Helper()
+		w := httptest.NewRecorder()
+		Handler(ready).ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		resp := w.Result()
+		tcompare(t, resp.StatusCode, expCode)
+		buf, err := io.ReadAll(resp.Body)
+		tcheck(t, err, "read response")
+		return string(buf)
+	}
+	tcompare(t, get("/healthz", false, 200), "ok listeners\nok queue\n")
+	body := get("/readyz", true, 200)
+	tcompare(t, strings.Contains(body, "ok store: 1 accounts\n"), true)
+
+	// Hostname no longer resolves.
+	resolver = dns.MockResolver{}
+	get("/healthz", false, 200)
+	body = get("/readyz", true, 503)
+	tcompare(t, strings.Contains(body, "error dns: "), true)
+}
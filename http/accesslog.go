@@ -0,0 +1,142 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mjl-/mox/config"
+)
+
+// accessLogLine is a line in the access log for WebHandlers, written as JSON.
+type accessLogLine struct {
+	Time       time.Time `json:"time"`
+	Handler    string    `json:"handler"`
+	RemoteIP   string    `json:"remoteip"`
+	User       string    `json:"user,omitempty"`
+	Method     string    `json:"method"`
+	Host       string    `json:"host"`
+	URL        string    `json:"url"`
+	Proto      string    `json:"proto"`
+	TLS        bool      `json:"tls"`
+	StatusCode int       `json:"statuscode"`
+	Size       int64     `json:"size"`             // Of response.
+	SizeIn     int64     `json:"sizein,omitempty"` // Of request body, or data from client for websockets.
+	Websocket  bool      `json:"websocket,omitempty"`
+	DurationMS float64   `json:"durationms"`
+	UserAgent  string    `json:"useragent,omitempty"`
+	Referrer   string    `json:"referrer,omitempty"`
+	Blocked    string    `json:"blocked,omitempty"` // "ratelimit", "rule", "bodysize".
+}
+
+// Current access log file, opened on first use.
+var accessLog = struct {
+	sync.Mutex
+	f    *os.File
+	size int64
+}{}
+
+func accessLogLineFor(w *loggingWriter) accessLogLine {
+	remoteIP := w.R.RemoteAddr
+	if host, _, err := net.SplitHostPort(remoteIP); err == nil {
+		remoteIP = host
+	}
+	size, sizeIn := w.Size, w.R.ContentLength
+	if w.WebsocketResponse {
+		size, sizeIn = w.SizeToClient, w.SizeFromClient
+	}
+	if sizeIn < 0 {
+		sizeIn = 0
+	}
+	return accessLogLine{
+		Time:       w.Start,
+		Handler:    w.Handler,
+		RemoteIP:   remoteIP,
+		User:       w.User,
+		Method:     w.R.Method,
+		Host:       w.R.Host,
+		URL:        w.R.URL.String(),
+		Proto:      strings.ToLower(w.R.Proto),
+		TLS:        w.R.TLS != nil,
+		StatusCode: w.StatusCode,
+		Size:       size,
+		SizeIn:     sizeIn,
+		Websocket:  w.WebsocketResponse,
+		DurationMS: float64(time.Since(w.Start)) / float64(time.Millisecond),
+		UserAgent:  w.R.Header.Get("User-Agent"),
+		Referrer:   w.R.Header.Get("Referer"),
+		Blocked:    w.Blocked,
+	}
+}
+
+// accessLogWrite adds a line to the access log, rotating the file when it reaches
+// its maximum size.
+func accessLogWrite(al *config.WebserverAccessLog, line accessLogLine) error {
+	buf, err := json.Marshal(line)
+	if err != nil {
+		return fmt.Errorf("marshal access log line: %v", err)
+	}
+	buf = append(buf, '\n')
+
+	maxSize := al.MaxSize
+	if maxSize == 0 {
+		maxSize = 100 * 1024 * 1024
+	}
+
+	accessLog.Lock()
+	defer accessLog.Unlock()
+
+	if accessLog.f != nil && accessLog.size > 0 && accessLog.size+int64(len(buf)) > maxSize {
+		err := accessLog.f.Close()
+		accessLog.f = nil
+		if err != nil {
+			return fmt.Errorf("closing access log for rotation: %v", err)
+		}
+		if err := accessLogRotate(al); err != nil {
+			return err
+		}
+	}
+	if accessLog.f == nil {
+		f, err := os.OpenFile(al.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+		if err != nil {
+			return fmt.Errorf("open access log: %v", err)
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("stat access log: %v", err)
+		}
+		accessLog.f = f
+		accessLog.size = fi.Size()
+	}
+	n, err := accessLog.f.Write(buf)
+	accessLog.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("write access log: %v", err)
+	}
+	return nil
+}
+
+// accessLogRotate renames the access log file to .1, after renaming earlier
+// rotated files to the next number. The oldest file is overwritten.
+func accessLogRotate(al *config.WebserverAccessLog) error {
+	maxFiles := al.MaxFiles
+	if maxFiles == 0 {
+		maxFiles = 5
+	}
+	for i := maxFiles - 1; i >= 0; i-- {
+		src := al.Path
+		if i > 0 {
+			src = fmt.Sprintf("%s.%d", al.Path, i)
+		}
+		dst := fmt.Sprintf("%s.%d", al.Path, i+1)
+		if err := os.Rename(src, dst); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("rotating access log: %v", err)
+		}
+	}
+	return nil
+}
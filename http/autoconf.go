@@ -1,10 +1,13 @@
 package http
 
 import (
+	"crypto/tls"
+	"encoding/json"
 	"encoding/xml"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"strings"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -247,6 +250,127 @@ func autodiscoverHandle(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Autodiscover V2 from Microsoft, used by Outlook before falling back to
+// autodiscover V1. Outlook requests a JSON document with the URL of the
+// autodiscover V1 endpoint for a protocol, at either of:
+//
+//   - https://autodiscover.example.org/autodiscover/autodiscover.json?Email=user%40example.org&Protocol=AutodiscoverV1
+//   - https://autodiscover.example.org/autodiscover/autodiscover.json/v1.0/user%40example.org?Protocol=AutodiscoverV1
+//
+// We only have settings through autodiscover V1 (for IMAP/SMTP), other protocols
+// like ActiveSync or EWS result in an error, after which Outlook tries IMAP/SMTP.
+//
+// See https://learn.microsoft.com/en-us/exchange/client-developer/exchange-web-services/autodiscover-for-exchange
+func autodiscoverJSONHandle(w http.ResponseWriter, r *http.Request) {
+	log := pkglog.WithContext(r.Context())
+
+	var addrDom string
+	defer func() {
+		metricAutodiscover.WithLabelValues(addrDom).Inc()
+	}()
+
+	if r.Method != "GET" {
+		http.Error(w, "405 - method not allowed - get required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON := func(status int, v any) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(v); err != nil {
+			log.Errorx("marshal autodiscover json response", err)
+		}
+	}
+	writeError := func(status int, code, msg string) {
+		writeJSON(status, autodiscoverJSONError{code, msg})
+	}
+
+	// Parameter names are case-insensitive in practice.
+	param := func(k string) string {
+		for name, l := range r.URL.Query() {
+			if strings.EqualFold(name, k) && len(l) > 0 {
+				return l[0]
+			}
+		}
+		return ""
+	}
+
+	email := param("Email")
+	if t := strings.Split(r.URL.Path, "/"); len(t) == 5 && strings.EqualFold(t[3], "v1.0") {
+		email = t[4]
+	} else if r.URL.Path != "/autodiscover/autodiscover.json" {
+		writeError(http.StatusNotFound, "InvalidPath", "unrecognized path")
+		return
+	}
+	protocol := param("Protocol")
+
+	log.Debug("autodiscover json request", slog.String("email", email), slog.String("protocol", protocol))
+
+	addr, err := smtp.ParseAddress(email)
+	if err != nil {
+		writeError(http.StatusBadRequest, "InvalidEmailAddress", "invalid email address")
+		return
+	}
+	addrDom = addr.Domain.Name()
+
+	if !strings.EqualFold(protocol, "AutodiscoverV1") {
+		writeError(http.StatusBadRequest, "InvalidProtocol", fmt.Sprintf("The given protocol value '%s' is invalid. Supported values are 'AutodiscoverV1'.", protocol))
+		return
+	}
+
+	var u url.URL
+	u.Scheme = "https"
+	if r.TLS == nil {
+		u.Scheme = "http"
+	}
+	u.Host = r.Host
+	u.Path = "/autodiscover/autodiscover.xml"
+	writeJSON(http.StatusOK, autodiscoverJSONResponse{"AutodiscoverV1", u.String()})
+}
+
+type autodiscoverJSONResponse struct {
+	Protocol string
+	URL      string `json:"Url"`
+}
+
+type autodiscoverJSONError struct {
+	ErrorCode    string
+	ErrorMessage string
+}
+
+// Client settings as JSON, for integrators: all client-relevant endpoints for an
+// email address, with setup instructions for common applications. Served at
+// https://autoconfig.example.org/client-settings.json?emailaddress=user%40example.org.
+func clientSettingsHandle(w http.ResponseWriter, r *http.Request) {
+	log := pkglog.WithContext(r.Context())
+
+	if r.Method != "GET" {
+		http.Error(w, "405 - method not allowed - get required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	email := r.FormValue("emailaddress")
+	log.Debug("client settings request", slog.String("email", email))
+	addr, err := smtp.ParseAddress(email)
+	if err != nil {
+		http.Error(w, "400 - bad request - invalid parameter emailaddress", http.StatusBadRequest)
+		return
+	}
+
+	cs, err := mox.ClientSettingsAddress(addr)
+	if err != nil {
+		http.Error(w, "400 - bad request - "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(cs); err != nil {
+		log.Errorx("marshal client settings response", err)
+	}
+}
+
 // Thunderbird requests these URLs for autoconfig/autodiscover:
 // https://autoconfig.example.org/mail/config-v1.1.xml?emailaddress=user%40example.org
 // https://autodiscover.example.org/autodiscover/autodiscover.xml
@@ -323,7 +447,18 @@ type autodiscoverProtocol struct {
 
 // Serve a .mobileconfig file. This endpoint is not a standard place where Apple
 // devices look. We point to it from the account page.
-func mobileconfigHandle(w http.ResponseWriter, r *http.Request) {
+//
+// If tlsConfig is not nil, the profile is signed with the TLS certificate for the
+// requested host name, so Apple software shows the profile as verified.
+func mobileconfigHandler(tlsConfig *tls.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		mobileconfigHandle(w, r, tlsConfig)
+	}
+}
+
+func mobileconfigHandle(w http.ResponseWriter, r *http.Request, tlsConfig *tls.Config) {
+	log := pkglog.WithContext(r.Context())
+
 	if r.Method != "GET" {
 		http.Error(w, "405 - method not allowed - get required", http.StatusMethodNotAllowed)
 		return
@@ -343,6 +478,15 @@ func mobileconfigHandle(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "400 - bad request - "+err.Error(), http.StatusBadRequest)
 		return
 	}
+	if tlsConfig != nil && r.TLS != nil {
+		if cert, err := serverCertificate(tlsConfig, r.TLS.ServerName); err != nil {
+			log.Infox("getting certificate for signing mobileconfig profile, serving unsigned profile", err)
+		} else if signed, err := signMobileConfig(buf, cert); err != nil {
+			log.Infox("signing mobileconfig profile, serving unsigned profile", err)
+		} else {
+			buf = signed
+		}
+	}
 	h := w.Header()
 	filename := l[0]
 	filename = strings.ReplaceAll(filename, ".", "-")
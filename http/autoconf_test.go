@@ -1,8 +1,21 @@
 package http
 
 import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/json"
 	"encoding/xml"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func TestAutodiscover(t *testing.T) {
@@ -24,3 +37,110 @@ func TestAutodiscover(t *testing.T) {
 		t.Fatalf("emailaddress: got %q, expected %q", req.Request.EmailAddress, "test@example.org")
 	}
 }
+
+func TestAutodiscoverJSON(t *testing.T) {
+	test := func(path string, expStatus int, exp any) {
+		t.Helper()
+		req := httptest.NewRequest("GET", path, nil)
+		req.Host = "autodiscover.mox.example"
+		rec := httptest.NewRecorder()
+		autodiscoverJSONHandle(rec, req)
+		if rec.Code != expStatus {
+			t.Fatalf("%s: got status %d, expected %d", path, rec.Code, expStatus)
+		}
+		var got any
+		switch exp.(type) {
+		case autodiscoverJSONResponse:
+			var r autodiscoverJSONResponse
+			err := json.Unmarshal(rec.Body.Bytes(), &r)
+			tcheck(t, err, "parse response")
+			got = r
+		case autodiscoverJSONError:
+			var r autodiscoverJSONError
+			err := json.Unmarshal(rec.Body.Bytes(), &r)
+			tcheck(t, err, "parse error response")
+			r.ErrorMessage = ""
+			got = r
+		}
+		if got != exp {
+			t.Fatalf("%s: got %#v, expected %#v", path, got, exp)
+		}
+	}
+
+	v1 := autodiscoverJSONResponse{"AutodiscoverV1", "https://autodiscover.mox.example/autodiscover/autodiscover.xml"}
+	test("https://autodiscover.mox.example/autodiscover/autodiscover.json?Email=mjl%40mox.example&Protocol=AutodiscoverV1", http.StatusOK, v1)
+	test("https://autodiscover.mox.example/autodiscover/autodiscover.json/v1.0/mjl@mox.example?Protocol=autodiscoverv1&RedirectCount=1", http.StatusOK, v1)
+	test("https://autodiscover.mox.example/autodiscover/autodiscover.json/v1.0/mjl@mox.example?Protocol=ActiveSync", http.StatusBadRequest, autodiscoverJSONError{ErrorCode: "InvalidProtocol"})
+	test("https://autodiscover.mox.example/autodiscover/autodiscover.json?Email=bogus&Protocol=AutodiscoverV1", http.StatusBadRequest, autodiscoverJSONError{ErrorCode: "InvalidEmailAddress"})
+	test("https://autodiscover.mox.example/autodiscover/autodiscover.json/v2.0/mjl@mox.example/x?Protocol=AutodiscoverV1", http.StatusNotFound, autodiscoverJSONError{ErrorCode: "InvalidPath"})
+}
+
+func TestSignMobileConfig(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	tcheck(t, err, "generate key")
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1234),
+		DNSNames:     []string{"autoconfig.mox.example"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certBuf, err := x509.CreateCertificate(cryptorand.Reader, template, template, key.Public(), key)
+	tcheck(t, err, "create certificate")
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{certBuf}, PrivateKey: key}}}
+	cert, err := serverCertificate(tlsConfig, "autoconfig.mox.example")
+	tcheck(t, err, "get certificate")
+
+	profile := []byte("<plist></plist>\n")
+	buf, err := signMobileConfig(profile, cert)
+	tcheck(t, err, "sign")
+
+	// Parse the CMS SignedData and verify the signature.
+	var ci struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue `asn1:"explicit,tag:0"`
+	}
+	_, err = asn1.Unmarshal(buf, &ci)
+	tcheck(t, err, "parse content info")
+	if !ci.ContentType.Equal(oidSignedData) {
+		t.Fatalf("got content type %v, expected signed data", ci.ContentType)
+	}
+	var sd struct {
+		Version          int
+		DigestAlgorithms asn1.RawValue
+		EncapContentInfo struct {
+			EContentType asn1.ObjectIdentifier
+			EContent     []byte `asn1:"explicit,tag:0"`
+		}
+		Certificates asn1.RawValue `asn1:"tag:0"`
+		SignerInfos  []struct {
+			Version            int
+			SID                asn1.RawValue
+			DigestAlgorithm    asn1.RawValue
+			SignedAttrs        asn1.RawValue `asn1:"tag:0"`
+			SignatureAlgorithm asn1.RawValue
+			Signature          []byte
+		} `asn1:"set"`
+	}
+	_, err = asn1.Unmarshal(ci.Content.Bytes, &sd)
+	tcheck(t, err, "parse signed data")
+	if !bytes.Equal(sd.EncapContentInfo.EContent, profile) {
+		t.Fatalf("got content %q, expected %q", sd.EncapContentInfo.EContent, profile)
+	}
+	if !bytes.Equal(sd.Certificates.Bytes, certBuf) {
+		t.Fatalf("certificate not in signed data")
+	}
+	if len(sd.SignerInfos) != 1 {
+		t.Fatalf("got %d signer infos, expected 1", len(sd.SignerInfos))
+	}
+	si := sd.SignerInfos[0]
+	// Signature is over the signed attributes as SET.
+	attrs := append([]byte{0x31}, si.SignedAttrs.FullBytes[1:]...)
+	digest := sha256.Sum256(attrs)
+	if !ecdsa.VerifyASN1(&key.PublicKey, digest[:], si.Signature) {
+		t.Fatalf("signature does not verify")
+	}
+	contentDigest := sha256.Sum256(profile)
+	if !bytes.Contains(attrs, contentDigest[:]) {
+		t.Fatalf("signed attributes do not contain message digest")
+	}
+}
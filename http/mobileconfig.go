@@ -2,13 +2,22 @@ package http
 
 import (
 	"bytes"
+	"crypto"
+	"crypto/ecdsa"
 	"crypto/hmac"
+	cryptorand "crypto/rand"
+	"crypto/rsa"
 	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"slices"
 	"sort"
 	"strings"
+	"time"
 
 	"golang.org/x/exp/maps"
 
@@ -112,7 +121,7 @@ func (m dict) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
 // Multiple addresses can be passed, the first is used for IMAP/submission login,
 // and likely seen as primary account by Apple software.
 //
-// The config is not signed, so users must ignore warnings about unsigned profiles.
+// The config is not signed, see signMobileConfig.
 func MobileConfig(addresses []string, fullName string) ([]byte, error) {
 	if len(addresses) == 0 {
 		return nil, fmt.Errorf("need at least 1 address")
@@ -206,3 +215,135 @@ func MobileConfig(addresses []string, fullName string) ([]byte, error) {
 	}
 	return w.Bytes(), nil
 }
+
+// serverCertificate returns the certificate that tlsConfig would use for TLS
+// connections for serverName.
+func serverCertificate(tlsConfig *tls.Config, serverName string) (*tls.Certificate, error) {
+	if tlsConfig.GetCertificate != nil {
+		hello := &tls.ClientHelloInfo{
+			ServerName:        serverName,
+			SignatureSchemes:  []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256, tls.PSSWithSHA256, tls.PKCS1WithSHA256},
+			SupportedVersions: []uint16{tls.VersionTLS13, tls.VersionTLS12},
+		}
+		cert, err := tlsConfig.GetCertificate(hello)
+		if err != nil || cert != nil {
+			return cert, err
+		}
+	}
+	if len(tlsConfig.Certificates) == 0 {
+		return nil, errors.New("no certificate in tls config")
+	}
+	return &tlsConfig.Certificates[0], nil
+}
+
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidSHA256        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidRSAEncryption = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidECDSASHA256   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+)
+
+// signMobileConfig returns the profile in buf signed with the certificate and its
+// private key, as CMS SignedData (RFC 5652) with the profile embedded. Apple
+// software verifies the signature and certificate chain when installing the
+// profile. Only RSA and ECDSA keys are supported.
+func signMobileConfig(buf []byte, cert *tls.Certificate) ([]byte, error) {
+	if len(cert.Certificate) == 0 {
+		return nil, errors.New("certificate without data")
+	}
+	leaf := cert.Leaf
+	if leaf == nil {
+		var err error
+		leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return nil, fmt.Errorf("parsing certificate: %v", err)
+		}
+	}
+	signer, ok := cert.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("private key of type %T cannot sign", cert.PrivateKey)
+	}
+	var sigAlg []byte
+	switch signer.Public().(type) {
+	case *rsa.PublicKey:
+		sigAlg = derSeq(derMust(asn1.Marshal(oidRSAEncryption)), asn1.NullBytes)
+	case *ecdsa.PublicKey:
+		sigAlg = derSeq(derMust(asn1.Marshal(oidECDSASHA256)))
+	default:
+		return nil, fmt.Errorf("unsupported key type %T for signing", signer.Public())
+	}
+	digestAlg := derSeq(derMust(asn1.Marshal(oidSHA256)), asn1.NullBytes)
+
+	attr := func(oid asn1.ObjectIdentifier, value []byte) []byte {
+		return derSeq(derMust(asn1.Marshal(oid)), derSet(value))
+	}
+	digest := sha256.Sum256(buf)
+	signedAttrs := derSet(
+		attr(oidContentType, derMust(asn1.Marshal(oidData))),
+		attr(oidMessageDigest, derMust(asn1.Marshal(digest[:]))),
+		attr(oidSigningTime, derMust(asn1.Marshal(time.Now().UTC()))),
+	)
+	attrsDigest := sha256.Sum256(signedAttrs)
+	sig, err := signer.Sign(cryptorand.Reader, attrsDigest[:], crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("signing: %v", err)
+	}
+
+	version := derMust(asn1.Marshal(1))
+	signerInfo := derSeq(
+		version,
+		derSeq(leaf.RawIssuer, derMust(asn1.Marshal(leaf.SerialNumber))),
+		digestAlg,
+		derTagged(0, signedAttrs[derHeaderLen(signedAttrs):]), // Implicitly tagged SET.
+		sigAlg,
+		derMust(asn1.Marshal(sig)),
+	)
+	signedData := derSeq(
+		version,
+		derSet(digestAlg),
+		derSeq(derMust(asn1.Marshal(oidData)), derTagged(0, derMust(asn1.Marshal(buf)))),
+		derTagged(0, bytes.Join(cert.Certificate, nil)), // Implicitly tagged SET of certificates.
+		derSet(signerInfo),
+	)
+	return derSeq(derMust(asn1.Marshal(oidSignedData)), derTagged(0, signedData)), nil
+}
+
+func derMust(buf []byte, err error) []byte {
+	if err != nil {
+		panic(err)
+	}
+	return buf
+}
+
+func derRaw(class, tag int, elems ...[]byte) []byte {
+	return derMust(asn1.Marshal(asn1.RawValue{Class: class, Tag: tag, IsCompound: true, Bytes: bytes.Join(elems, nil)}))
+}
+
+func derSeq(elems ...[]byte) []byte {
+	return derRaw(asn1.ClassUniversal, asn1.TagSequence, elems...)
+}
+
+// derSet returns a DER SET, with the elements sorted as required for DER.
+func derSet(elems ...[]byte) []byte {
+	l := slices.Clone(elems)
+	slices.SortFunc(l, bytes.Compare)
+	return derRaw(asn1.ClassUniversal, asn1.TagSet, l...)
+}
+
+// derTagged returns a context-specific constructed value. Used for explicit tags
+// with an encoded value, and for implicit tags with the contents of a SET.
+func derTagged(tag int, contents []byte) []byte {
+	return derRaw(asn1.ClassContextSpecific, tag, contents)
+}
+
+// derHeaderLen returns the length of the tag and length bytes of the DER value.
+func derHeaderLen(buf []byte) int {
+	if buf[1] < 0x80 {
+		return 2
+	}
+	return 2 + int(buf[1]&0x7f)
+}
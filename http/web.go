@@ -26,13 +26,19 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
 
+	"github.com/mjl-/mox/adminapisrv"
 	"github.com/mjl-/mox/autotls"
 	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/dav"
 	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/health"
 	"github.com/mjl-/mox/mlog"
 	"github.com/mjl-/mox/mox-"
 	"github.com/mjl-/mox/ratelimit"
+	"github.com/mjl-/mox/replication"
 	"github.com/mjl-/mox/webaccount"
 	"github.com/mjl-/mox/webadmin"
 	"github.com/mjl-/mox/webapisrv"
@@ -99,6 +105,11 @@ type loggingWriter struct {
 	WebsocketResponse            bool        // If this was a successful websocket connection with backend.
 	SizeFromClient, SizeToClient int64       // Websocket data.
 	Attrs                        []slog.Attr // Additional fields to log.
+
+	// Set by WebHandle, for the access log.
+	AccessLog bool   // Whether request matched a WebHandler and is written to the access log.
+	User      string // Authenticated through access control.
+	Blocked   string // Reason request was refused by rate limit or rule.
 }
 
 func (w *loggingWriter) AddAttr(a slog.Attr) {
@@ -155,6 +166,26 @@ func (w *loggingWriter) Write(buf []byte) (int, error) {
 	return n, err
 }
 
+// ReadFrom copies the data from r. Without compression, the underlying response
+// writer does the copying, which can use sendfile for files on plain HTTP
+// connections.
+func (w *loggingWriter) ReadFrom(r io.Reader) (int64, error) {
+	if w.StatusCode == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	rf, ok := w.W.(io.ReaderFrom)
+	if w.Gzip != nil || !ok {
+		// Hide our ReadFrom from io.Copy to prevent recursion.
+		return io.Copy(struct{ io.Writer }{w}, r)
+	}
+	n, err := rf.ReadFrom(r)
+	w.Size += n
+	if err != nil {
+		w.error(err)
+	}
+	return n, err
+}
+
 func (w *loggingWriter) setStatusCode(statusCode int) {
 	if w.StatusCode != 0 {
 		return
@@ -349,6 +380,11 @@ func (w *loggingWriter) Done() {
 	}
 	attrs = append(attrs, w.Attrs...)
 	pkglog.WithContext(w.R.Context()).Debugx("http request", err, attrs...)
+
+	if al := mox.Conf.Static.WebserverAccessLog; al != nil && w.AccessLog {
+		err := accessLogWrite(al, accessLogLineFor(w))
+		pkglog.Check(err, "writing webserver access log")
+	}
 }
 
 // Set some http headers that should prevent potential abuse. Better safe than sorry.
@@ -375,6 +411,7 @@ type serve struct {
 	TLSConfig    *tls.Config
 	PathHandlers []pathHandler // Sorted, longest first.
 	Webserver    bool          // Whether serving WebHandler. PathHandlers are always evaluated before WebHandlers.
+	AltSvc       string        // If set, Alt-Svc header for HTTPS responses over TCP, advertising HTTP/3.
 }
 
 // Handle registers a named handler for a path and optional host. If path ends with
@@ -423,6 +460,10 @@ func (s *serve) ServeHTTP(xw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if s.AltSvc != "" && r.TLS != nil && r.ProtoMajor < 3 {
+		xw.Header().Set("Alt-Svc", s.AltSvc)
+	}
+
 	ctx := context.WithValue(r.Context(), mlog.CidKey, mox.Cid())
 	r = r.WithContext(ctx)
 
@@ -507,7 +548,7 @@ func Listen() {
 		ensureServe = func(https bool, port int, kind string) *serve {
 			s := portServe[port]
 			if s == nil {
-				s = &serve{nil, nil, nil, false}
+				s = &serve{nil, nil, nil, false, ""}
 				portServe[port] = s
 			}
 			s.Kinds = append(s.Kinds, kind)
@@ -602,6 +643,69 @@ func Listen() {
 			redirectToTrailingSlash(srv, "webapi", path)
 		}
 
+		if l.AdminAPIHTTP.Enabled {
+			port := config.Port(l.AdminAPIHTTP.Port, 80)
+			path := "/adminapi/"
+			if l.AdminAPIHTTP.Path != "" {
+				path = l.AdminAPIHTTP.Path
+			}
+			srv := ensureServe(false, port, "adminapi-http at "+path)
+			handler := safeHeaders(http.StripPrefix(path[:len(path)-1], adminapisrv.NewServer(path, l.AdminAPIHTTP.Forwarded)))
+			srv.Handle("adminapi", nil, path, handler)
+			redirectToTrailingSlash(srv, "adminapi", path)
+		}
+		if l.AdminAPIHTTPS.Enabled {
+			port := config.Port(l.AdminAPIHTTPS.Port, 443)
+			path := "/adminapi/"
+			if l.AdminAPIHTTPS.Path != "" {
+				path = l.AdminAPIHTTPS.Path
+			}
+			srv := ensureServe(true, port, "adminapi-https at "+path)
+			handler := safeHeaders(http.StripPrefix(path[:len(path)-1], adminapisrv.NewServer(path, l.AdminAPIHTTPS.Forwarded)))
+			srv.Handle("adminapi", nil, path, handler)
+			redirectToTrailingSlash(srv, "adminapi", path)
+		}
+
+		if l.DAVHTTP.Enabled {
+			port := config.Port(l.DAVHTTP.Port, 80)
+			path := "/dav/"
+			if l.DAVHTTP.Path != "" {
+				path = l.DAVHTTP.Path
+			}
+			srv := ensureServe(false, port, "dav-http at "+path)
+			handler := safeHeaders(http.StripPrefix(path[:len(path)-1], dav.NewServer(path, l.DAVHTTP.Forwarded)))
+			srv.Handle("dav", nil, path, handler)
+			redirectToTrailingSlash(srv, "dav", path)
+			// Service discovery for CardDAV and CalDAV clients, RFC 6764.
+			srv.Handle("dav", nil, "/.well-known/carddav", safeHeaders(http.RedirectHandler(path, http.StatusMovedPermanently)))
+			srv.Handle("dav", nil, "/.well-known/caldav", safeHeaders(http.RedirectHandler(path, http.StatusMovedPermanently)))
+		}
+		if l.DAVHTTPS.Enabled {
+			port := config.Port(l.DAVHTTPS.Port, 443)
+			path := "/dav/"
+			if l.DAVHTTPS.Path != "" {
+				path = l.DAVHTTPS.Path
+			}
+			srv := ensureServe(true, port, "dav-https at "+path)
+			handler := safeHeaders(http.StripPrefix(path[:len(path)-1], dav.NewServer(path, l.DAVHTTPS.Forwarded)))
+			srv.Handle("dav", nil, path, handler)
+			redirectToTrailingSlash(srv, "dav", path)
+			// Service discovery for CardDAV and CalDAV clients, RFC 6764.
+			srv.Handle("dav", nil, "/.well-known/carddav", safeHeaders(http.RedirectHandler(path, http.StatusMovedPermanently)))
+			srv.Handle("dav", nil, "/.well-known/caldav", safeHeaders(http.RedirectHandler(path, http.StatusMovedPermanently)))
+		}
+
+		if l.ReplicationHTTPS.Enabled {
+			port := config.Port(l.ReplicationHTTPS.Port, 443)
+			path := "/replication/"
+			if l.ReplicationHTTPS.Path != "" {
+				path = l.ReplicationHTTPS.Path
+			}
+			srv := ensureServe(true, port, "replication-https at "+path)
+			handler := safeHeaders(http.StripPrefix(path[:len(path)-1], replication.Handler(l.ReplicationHTTPS.Forwarded)))
+			srv.Handle("replication", nil, path, handler)
+		}
+
 		if l.WebmailHTTP.Enabled {
 			port := config.Port(l.WebmailHTTP.Port, 80)
 			path := "/webmail/"
@@ -643,6 +747,8 @@ func Listen() {
 			port := config.Port(l.MetricsHTTP.Port, 8010)
 			srv := ensureServe(false, port, "metrics-http")
 			srv.Handle("metrics", nil, "/metrics", safeHeaders(promhttp.Handler()))
+			srv.Handle("healthz", nil, "/healthz", safeHeaders(health.Handler(false)))
+			srv.Handle("readyz", nil, "/readyz", safeHeaders(health.Handler(true)))
 			srv.Handle("metrics", nil, "/", safeHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				if r.URL.Path != "/" {
 					http.NotFound(w, r)
@@ -652,7 +758,7 @@ func Listen() {
 					return
 				}
 				w.Header().Set("Content-Type", "text/html")
-				fmt.Fprint(w, `<html><body>see <a href="metrics">metrics</a></body></html>`)
+				fmt.Fprint(w, `<html><body>see <a href="metrics">metrics</a>, <a href="healthz">healthz</a> and <a href="readyz">readyz</a></body></html>`)
 			})))
 		}
 		if l.AutoconfigHTTPS.Enabled {
@@ -675,7 +781,10 @@ func Listen() {
 			}
 			srv.Handle("autoconfig", autoconfigMatch, "/mail/config-v1.1.xml", safeHeaders(http.HandlerFunc(autoconfHandle)))
 			srv.Handle("autodiscover", autoconfigMatch, "/autodiscover/autodiscover.xml", safeHeaders(http.HandlerFunc(autodiscoverHandle)))
-			srv.Handle("mobileconfig", autoconfigMatch, "/profile.mobileconfig", safeHeaders(http.HandlerFunc(mobileconfigHandle)))
+			srv.Handle("autodiscoverjson", autoconfigMatch, "/autodiscover/autodiscover.json", safeHeaders(http.HandlerFunc(autodiscoverJSONHandle)))
+			srv.Handle("autodiscoverjson", autoconfigMatch, "/autodiscover/autodiscover.json/", safeHeaders(http.HandlerFunc(autodiscoverJSONHandle)))
+			srv.Handle("clientsettings", autoconfigMatch, "/client-settings.json", safeHeaders(http.HandlerFunc(clientSettingsHandle)))
+			srv.Handle("mobileconfig", autoconfigMatch, "/profile.mobileconfig", safeHeaders(mobileconfigHandler(srv.TLSConfig)))
 			srv.Handle("mobileconfigqrcodepng", autoconfigMatch, "/profile.mobileconfig.qrcode.png", safeHeaders(http.HandlerFunc(mobileconfigQRCodeHandle)))
 		}
 		if l.MTASTSHTTPS.Enabled {
@@ -687,13 +796,21 @@ func Listen() {
 			}
 			srv.Handle("mtasts", mtastsMatch, "/.well-known/mta-sts.txt", safeHeaders(http.HandlerFunc(mtastsPolicyHandle)))
 		}
+		if l.WKDHTTPS.Enabled {
+			port := config.Port(l.WKDHTTPS.Port, 443)
+			srv := ensureServe(!l.WKDHTTPS.NonTLS, port, "wkd-https")
+			wkdMatch := func(dom dns.Domain) bool {
+				return strings.HasPrefix(dom.ASCII, "openpgpkey.")
+			}
+			srv.Handle("wkd", wkdMatch, "/.well-known/openpgpkey/", safeHeaders(http.HandlerFunc(wkdHandle)))
+		}
 		if l.PprofHTTP.Enabled {
 			// Importing net/http/pprof registers handlers on the default serve mux.
 			port := config.Port(l.PprofHTTP.Port, 8011)
 			if _, ok := portServe[port]; ok {
 				pkglog.Fatal("cannot serve pprof on same endpoint as other http services")
 			}
-			srv := &serve{[]string{"pprof-http"}, nil, nil, false}
+			srv := &serve{[]string{"pprof-http"}, nil, nil, false, ""}
 			portServe[port] = srv
 			srv.Handle("pprof", nil, "/", http.DefaultServeMux)
 		}
@@ -759,8 +876,15 @@ func Listen() {
 				// Longest paths first.
 				return len(a) > len(b)
 			})
+			http3 := l.HTTP3 && srv.TLSConfig != nil
+			if http3 {
+				srv.AltSvc = fmt.Sprintf(`h3=":%d"; ma=86400`, port)
+			}
 			for _, ip := range l.IPs {
 				listen1(ip, port, srv.TLSConfig, name, srv.Kinds, srv)
+				if http3 {
+					listenHTTP3(ip, port, srv.TLSConfig, name, srv.Kinds, srv)
+				}
 			}
 		}
 	}
@@ -807,6 +931,7 @@ func listen1(ip string, port int, tlsConfig *tls.Config, name string, kinds []st
 		if err != nil {
 			pkglog.Fatalx("https: listen", err, slog.String("addr", addr))
 		}
+		tlsConfig = httpTLSConfig(tlsConfig)
 		ln = tls.NewListener(ln, tlsConfig)
 	}
 
@@ -825,6 +950,50 @@ func listen1(ip string, port int, tlsConfig *tls.Config, name string, kinds []st
 	servers = append(servers, serve)
 }
 
+// listenHTTP3 prepares a UDP listener for HTTP/3 over QUIC, and adds it to
+// "servers", like listen1.
+func listenHTTP3(ip string, port int, tlsConfig *tls.Config, name string, kinds []string, handler http.Handler) {
+	addr := net.JoinHostPort(ip, fmt.Sprintf("%d", port))
+	if os.Getuid() == 0 {
+		pkglog.Print("http3 listener",
+			slog.String("name", name),
+			slog.String("kinds", strings.Join(kinds, ",")),
+			slog.String("address", addr))
+	}
+	network := strings.Replace(mox.Network(ip), "tcp", "udp", 1)
+	conn, err := mox.ListenPacket(network, addr)
+	if err != nil {
+		pkglog.Fatalx("http3: listen", err, slog.String("addr", addr))
+	}
+
+	server := &http3.Server{
+		Handler: handler,
+		// Sets the ALPN protocol for HTTP/3 for each connection.
+		TLSConfig: http3.ConfigureTLSConfig(tlsConfig.Clone()),
+		QUICConfig: &quic.Config{
+			MaxIdleTimeout: 65 * time.Second, // Like IdleTimeout for HTTP/1 and HTTP/2.
+		},
+	}
+	serve := func() {
+		err := server.Serve(conn)
+		pkglog.Fatalx("http3: serve", err)
+	}
+	servers = append(servers, serve)
+}
+
+// httpTLSConfig returns a copy of the TLS config of a listener for use with HTTPS.
+// If the config doesn't specify application protocols, HTTP/2 and HTTP/1.1 are
+// offered through ALPN. The http.Server serves HTTP/2 for connections that
+// negotiate it. Configs from an ACME manager for port 443 already offer both,
+// along with the protocol for the tls-alpn-01 challenge.
+func httpTLSConfig(tlsConfig *tls.Config) *tls.Config {
+	c := tlsConfig.Clone()
+	if len(c.NextProtos) == 0 {
+		c.NextProtos = []string{"h2", "http/1.1"}
+	}
+	return c
+}
+
 // Serve starts serving on the initialized listeners.
 func Serve() {
 	loadStaticGzipCache(mox.DataDirPath("tmp/httpstaticcompresscache"), 512*1024*1024)
@@ -841,6 +1010,11 @@ func Serve() {
 		i := 0
 		for m, hosts := range ensureManagerHosts {
 			for host := range hosts {
+				// Certificates for DNS-01 names are requested by the manager itself.
+				if m.DNS01Host(host) {
+					continue
+				}
+
 				// Check if certificate is already available. If so, we don't print as much after a
 				// restart, and finish more quickly if only a few certificates are missing/old.
 				if avail, err := m.CertAvailable(mox.Shutdown, pkglog, host); err != nil {
@@ -871,7 +1045,7 @@ func Serve() {
 					SupportedVersions: []uint16{tls.VersionTLS13},
 				}
 				pkglog.Print("ensuring certificate availability", slog.Any("hostname", host))
-				if _, err := m.Manager.GetCertificate(hello); err != nil {
+				if _, err := m.GetCertificate(hello); err != nil {
 					pkglog.Errorx("requesting automatic certificate", err, slog.Any("hostname", host))
 				}
 			}
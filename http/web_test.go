@@ -2,12 +2,23 @@ package http
 
 import (
 	"bytes"
+	"crypto/ed25519"
+	cryptorand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
 
 	"github.com/mjl-/mox/dns"
 	"github.com/mjl-/mox/mox-"
@@ -67,3 +78,136 @@ func TestServeHTTP(t *testing.T) {
 	test("GET", "http://mox.example/static/dir/", http.StatusOK, "", map[string]string{"X-Test": "mox"}) // Dir listing.
 	test("GET", "http://mox.example/other", http.StatusNotFound, "", nil)
 }
+
+func testCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+	privKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"mox.example"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certBuf, err := x509.CreateCertificate(cryptorand.Reader, template, template, privKey.Public(), privKey)
+	if err != nil {
+		t.Fatalf("making certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{certBuf}, PrivateKey: privKey}
+}
+
+func TestHTTP2(t *testing.T) {
+	cert := testCertificate(t)
+
+	// Listeners without ALPN protocols get HTTP/2 and HTTP/1.1, others are kept.
+	acmeConfig := &tls.Config{NextProtos: []string{"h2", "http/1.1", "acme-tls/1"}}
+	if l := httpTLSConfig(acmeConfig).NextProtos; len(l) != 3 {
+		t.Fatalf("got next protos %v, expected unchanged", l)
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+	httpConfig := httpTLSConfig(tlsConfig)
+	if len(tlsConfig.NextProtos) != 0 {
+		t.Fatalf("original tls config was modified")
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Proto))
+		}),
+		TLSConfig: httpConfig,
+	}
+	go server.Serve(tls.NewListener(ln, httpConfig))
+	defer server.Close()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			ForceAttemptHTTP2: true,
+		},
+	}
+	resp, err := client.Get("https://" + ln.Addr().String() + "/")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer resp.Body.Close()
+	buf, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading response: %v", err)
+	}
+	if resp.ProtoMajor != 2 || string(buf) != "HTTP/2.0" {
+		t.Fatalf("got protocol %s, server saw %q, expected HTTP/2.0", resp.Proto, buf)
+	}
+}
+
+func TestHTTP3(t *testing.T) {
+	os.RemoveAll("../testdata/web/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/web/mox.conf")
+	mox.ConfigDynamicPath = filepath.Join(filepath.Dir(mox.ConfigStaticPath), "domains.conf")
+	mox.MustLoadConfig(true, false)
+	mox.FilesImmediate = true
+	defer func() {
+		mox.FilesImmediate = false
+	}()
+
+	// Find a free UDP port.
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	conn.Close()
+
+	srv := &serve{
+		PathHandlers: []pathHandler{
+			{
+				Path: "/",
+				Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Write([]byte(r.Proto))
+				}),
+			},
+		},
+		AltSvc: fmt.Sprintf(`h3=":%d"; ma=86400`, port),
+	}
+
+	// HTTPS responses over TCP advertise HTTP/3, plain HTTP responses don't.
+	req := httptest.NewRequest("GET", "https://mox.example/", nil)
+	rw := httptest.NewRecorder()
+	srv.ServeHTTP(rw, req)
+	if h := rw.Result().Header.Get("Alt-Svc"); h != srv.AltSvc {
+		t.Fatalf("got alt-svc %q, expected %q", h, srv.AltSvc)
+	}
+	req = httptest.NewRequest("GET", "http://mox.example/", nil)
+	rw = httptest.NewRecorder()
+	srv.ServeHTTP(rw, req)
+	if h := rw.Result().Header.Get("Alt-Svc"); h != "" {
+		t.Fatalf("got alt-svc %q for plain http, expected none", h)
+	}
+
+	servers = nil
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{testCertificate(t)}}
+	listenHTTP3("127.0.0.1", port, tlsConfig, "test", []string{"test"}, srv)
+	go servers[0]()
+	servers = nil
+
+	transport := &http3.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	defer transport.Close()
+	client := &http.Client{Transport: transport}
+	resp, err := client.Get(fmt.Sprintf("https://127.0.0.1:%d/", port))
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer resp.Body.Close()
+	buf, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading response: %v", err)
+	}
+	if resp.ProtoMajor != 3 || string(buf) != "HTTP/3.0" {
+		t.Fatalf("got protocol %s, server saw %q, expected HTTP/3.0", resp.Proto, buf)
+	}
+	if h := resp.Header.Get("Alt-Svc"); h != "" {
+		t.Fatalf("got alt-svc %q over http/3, expected none", h)
+	}
+}
@@ -0,0 +1,463 @@
+package http
+
+import (
+	cryptorand "crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/oidc"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/store"
+)
+
+// Path on webserver domains that the identity provider sends users back to after
+// logging in for WebHandlers with OIDC access control.
+const webOIDCCallbackPath = "/.mox-oidc-callback"
+
+const (
+	webOIDCLoginTimeout    = 10 * time.Minute
+	webOIDCSessionLifetime = 24 * time.Hour
+	webOIDCStateCookie     = "moxwebstate"
+	webOIDCSessionCookie   = "moxwebsession"
+)
+
+// Delay before responding to failed basic authentication, to slow down password
+// guessing.
+var webBadAuthDelay = time.Second
+
+// webOIDCLogin is a pending login through the identity provider.
+type webOIDCLogin struct {
+	host         dns.Domain
+	nonce        string
+	codeVerifier string
+	redirectURI  string
+	returnPath   string // Path and query string of the original request.
+	expires      time.Time
+}
+
+// webOIDCSession is a logged in user, for a single domain.
+type webOIDCSession struct {
+	host    dns.Domain
+	email   string
+	expires time.Time
+}
+
+// Pending logins, keyed by state, and sessions, keyed by session token. Both are
+// only kept in memory.
+var webOIDC = struct {
+	sync.Mutex
+	logins   map[string]webOIDCLogin
+	sessions map[string]webOIDCSession
+}{logins: map[string]webOIDCLogin{}, sessions: map[string]webOIDCSession{}}
+
+func webRandom() string {
+	var buf [16]byte
+	if _, err := cryptorand.Read(buf[:]); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf[:])
+}
+
+// webAccess checks whether a request is allowed access to a WebHandler with access
+// control. If not, an error response or redirect to the identity provider is
+// written and false returned. If the request is authenticated, the username or
+// email address is returned.
+func webAccess(wa *config.WebAccess, host dns.Domain, w *loggingWriter, r *http.Request) (user string, ok bool) {
+	log := pkglog.WithContext(r.Context())
+
+	var remoteIP net.IP
+	if ipstr, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		remoteIP = net.ParseIP(ipstr)
+	}
+	if remoteIP == nil {
+		http.Error(w, "500 - internal server error - cannot find remote ip"+recvid(r), http.StatusInternalServerError)
+		return "", false
+	}
+	contains := func(l []net.IPNet) bool {
+		return slices.ContainsFunc(l, func(ipnet net.IPNet) bool { return ipnet.Contains(remoteIP) })
+	}
+	if contains(wa.IPDenyNets) || len(wa.IPAllowNets) > 0 && !contains(wa.IPAllowNets) {
+		log.Debug("webhandler access refused for ip", slog.Any("remoteip", remoteIP))
+		http.Error(w, "403 - forbidden"+recvid(r), http.StatusForbidden)
+		return "", false
+	}
+
+	if wa.BasicAuth == nil && wa.OIDC == nil {
+		return "", true
+	}
+	if r.TLS == nil {
+		http.Error(w, "403 - forbidden - authentication requires https"+recvid(r), http.StatusForbidden)
+		return "", false
+	}
+
+	if wa.BasicAuth != nil {
+		user, ok = webBasicAuth(log, wa.BasicAuth, host, remoteIP, w, r)
+		if ok {
+			// Credentials are for us, not for a backend.
+			r.Header.Del("Authorization")
+		}
+	} else {
+		user, ok = webOIDCAuth(log, wa.OIDC, host, w, r)
+	}
+	if ok {
+		w.User = user
+		w.AddAttr(slog.String("user", user))
+	}
+	return user, ok
+}
+
+func webBasicAuth(log mlog.Log, ba *config.WebBasicAuth, host dns.Domain, remoteIP net.IP, w http.ResponseWriter, r *http.Request) (string, bool) {
+	unauthorized := func() {
+		realm := ba.Realm
+		if realm == "" {
+			realm = host.Name()
+		}
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm="%s", charset="UTF-8"`, strings.ReplaceAll(realm, `"`, "")))
+		http.Error(w, "401 - unauthorized"+recvid(r), http.StatusUnauthorized)
+	}
+
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		unauthorized()
+		return "", false
+	}
+
+	t0 := time.Now()
+	if !mox.LimiterFailedAuth.CanAdd(remoteIP, t0, 1) {
+		metrics.AuthenticationRatelimitedInc("webserver")
+		log.Debug("refusing basic authentication due to many auth failures", slog.Any("remoteip", remoteIP))
+		http.Error(w, "429 - too many auth attempts"+recvid(r), http.StatusTooManyRequests)
+		return "", false
+	}
+
+	authResult := "error"
+	defer func() {
+		metrics.AuthenticationInc("webserver", "httpbasic", authResult)
+	}()
+
+	valid, err := webBasicAuthVerify(log, ba, username, password)
+	if err != nil {
+		log.Errorx("verifying basic authentication credentials", err, slog.String("username", username))
+		http.Error(w, "500 - internal server error - error verifying credentials"+recvid(r), http.StatusInternalServerError)
+		return "", false
+	} else if !valid {
+		mox.LimiterFailedAuth.Add(remoteIP, t0, 1)
+		mox.AutoBanFailure(log, remoteIP, mox.AutoBanAuth)
+		authResult = "badcreds"
+		log.Debug("bad basic authentication credentials", slog.String("username", username))
+		time.Sleep(webBadAuthDelay)
+		unauthorized()
+		return "", false
+	}
+	authResult = "ok"
+	mox.LimiterFailedAuth.Reset(remoteIP, t0)
+	return username, true
+}
+
+// webBasicAuthVerify checks the credentials against the htpasswd file, then the
+// accounts.
+func webBasicAuthVerify(log mlog.Log, ba *config.WebBasicAuth, username, password string) (bool, error) {
+	if ba.HTPasswdPath != "" {
+		hash, err := htpasswdHash(ba.HTPasswdPath, username)
+		if err != nil {
+			return false, fmt.Errorf("reading htpasswd file: %v", err)
+		} else if hash != "" {
+			return htpasswdVerify(hash, password), nil
+		}
+	}
+	if !ba.AllAccounts && len(ba.Accounts) == 0 {
+		return false, nil
+	}
+
+	acc, err := store.OpenEmailAuth(log, username, password)
+	if err != nil {
+		if errors.Is(err, mox.ErrDomainNotFound) || errors.Is(err, mox.ErrAddressNotFound) || errors.Is(err, store.ErrUnknownCredentials) || errors.Is(err, smtp.ErrBadAddress) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+	return ba.AllAccounts || slices.Contains(ba.Accounts, acc.Name), nil
+}
+
+// Parsed htpasswd files, read again when modified. And successful
+// verifications, to prevent a bcrypt comparison for each request.
+var htpasswdFiles = struct {
+	sync.Mutex
+	files   map[string]htpasswdFile
+	success map[string]string // Hash to password.
+}{files: map[string]htpasswdFile{}, success: map[string]string{}}
+
+type htpasswdFile struct {
+	modTime time.Time
+	size    int64
+	hashes  map[string]string // Username to hash.
+}
+
+// htpasswdHash returns the password hash for username from the htpasswd file, or
+// an empty string if the user is not present.
+func htpasswdHash(path, username string) (string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	htpasswdFiles.Lock()
+	defer htpasswdFiles.Unlock()
+	if hf, ok := htpasswdFiles.files[path]; ok && hf.modTime.Equal(fi.ModTime()) && hf.size == fi.Size() {
+		return hf.hashes[username], nil
+	}
+
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	hashes := map[string]string{}
+	for _, line := range strings.Split(string(buf), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if user, hash, ok := strings.Cut(line, ":"); ok {
+			hashes[user] = hash
+		}
+	}
+	htpasswdFiles.files[path] = htpasswdFile{fi.ModTime(), fi.Size(), hashes}
+	// Forget successful verifications of hashes that may no longer be present.
+	htpasswdFiles.success = map[string]string{}
+	return hashes[username], nil
+}
+
+// htpasswdVerify returns whether password matches the bcrypt hash from an htpasswd
+// file. Other hash types are not supported.
+func htpasswdVerify(hash, password string) bool {
+	if !strings.HasPrefix(hash, "$2y$") && !strings.HasPrefix(hash, "$2a$") && !strings.HasPrefix(hash, "$2b$") {
+		return false
+	}
+
+	htpasswdFiles.Lock()
+	pw, ok := htpasswdFiles.success[hash]
+	htpasswdFiles.Unlock()
+	if ok && pw == password {
+		return true
+	}
+
+	// The bcrypt package only knows $2a$ and $2b$, $2y$ from htpasswd is the same.
+	if bcrypt.CompareHashAndPassword([]byte(strings.Replace(hash, "$2y$", "$2a$", 1)), []byte(password)) != nil {
+		return false
+	}
+	htpasswdFiles.Lock()
+	htpasswdFiles.success[hash] = password
+	htpasswdFiles.Unlock()
+	return true
+}
+
+func webOIDCAuth(log mlog.Log, wo *config.WebOIDCAuth, host dns.Domain, w http.ResponseWriter, r *http.Request) (string, bool) {
+	if c, err := r.Cookie(webOIDCSessionCookie); err == nil {
+		webOIDC.Lock()
+		sess, ok := webOIDC.sessions[c.Value]
+		webOIDC.Unlock()
+		if ok && sess.host == host && time.Now().Before(sess.expires) {
+			if !webOIDCAllowed(wo, sess.email) {
+				log.Debug("webhandler access refused for user", slog.String("email", sess.email))
+				http.Error(w, "403 - forbidden"+recvid(r), http.StatusForbidden)
+				return "", false
+			}
+
+			// The session token is for us, not for a backend.
+			cookies := r.Cookies()
+			r.Header.Del("Cookie")
+			for _, c := range cookies {
+				if c.Name != webOIDCSessionCookie {
+					r.AddCookie(c)
+				}
+			}
+			return sess.email, true
+		}
+	}
+
+	// Only navigations can be sent to the identity provider.
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "401 - unauthorized - login required"+recvid(r), http.StatusUnauthorized)
+		return "", false
+	}
+
+	p := oidc.Get(mox.Conf.Static.OIDC)
+	if p == nil {
+		http.Error(w, "500 - internal server error - single sign-on not configured"+recvid(r), http.StatusInternalServerError)
+		return "", false
+	}
+	login := webOIDCLogin{
+		host:         host,
+		nonce:        webRandom(),
+		codeVerifier: webRandom() + webRandom(), // RFC 7636 section 4.1 requires at least 43 characters.
+		redirectURI:  "https://" + host.ASCII + webOIDCCallbackPath,
+		returnPath:   r.URL.RequestURI(),
+		expires:      time.Now().Add(webOIDCLoginTimeout),
+	}
+	state := webRandom()
+	authURL, err := p.AuthURL(r.Context(), log, login.redirectURI, state, login.nonce, login.codeVerifier)
+	if err != nil {
+		log.Errorx("preparing single sign-on login", err)
+		http.Error(w, "502 - bad gateway - cannot reach identity provider"+recvid(r), http.StatusBadGateway)
+		return "", false
+	}
+
+	webOIDC.Lock()
+	now := time.Now()
+	for k, l := range webOIDC.logins {
+		if now.After(l.expires) {
+			delete(webOIDC.logins, k)
+		}
+	}
+	// Prevent unbounded growth from unfinished logins.
+	if len(webOIDC.logins) >= 1000 {
+		for k := range webOIDC.logins {
+			delete(webOIDC.logins, k)
+			break
+		}
+	}
+	webOIDC.logins[state] = login
+	webOIDC.Unlock()
+
+	// Sent along with the redirect from the identity provider, so the cookie
+	// can't be SameSite strict.
+	http.SetCookie(w, &http.Cookie{
+		Name:     webOIDCStateCookie,
+		Value:    state,
+		Path:     webOIDCCallbackPath,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(webOIDCLoginTimeout / time.Second),
+	})
+	http.Redirect(w, r, authURL, http.StatusSeeOther)
+	return "", false
+}
+
+// webOIDCAllowed returns whether the user with email address is allowed access.
+func webOIDCAllowed(wo *config.WebOIDCAuth, email string) bool {
+	if len(wo.Emails) == 0 && len(wo.Domains) == 0 {
+		return true
+	}
+	addr, err := smtp.ParseAddress(email)
+	if err != nil {
+		return false
+	}
+	for _, s := range wo.Emails {
+		if a, err := smtp.ParseAddress(s); err == nil && a == addr {
+			return true
+		}
+	}
+	for _, s := range wo.Domains {
+		if d, err := dns.ParseDomain(s); err == nil && d == addr.Domain {
+			return true
+		}
+	}
+	return false
+}
+
+// webOIDCHasHandler returns whether a WebHandler for host requires OIDC login.
+func webOIDCHasHandler(handlers []config.WebHandler, host dns.Domain) bool {
+	return slices.ContainsFunc(handlers, func(h config.WebHandler) bool {
+		return h.DNSDomain == host && h.Access != nil && h.Access.OIDC != nil
+	})
+}
+
+// webOIDCCallback finishes a login through the identity provider, adding a
+// session and redirecting to the originally requested page.
+func webOIDCCallback(w http.ResponseWriter, r *http.Request, host dns.Domain) {
+	log := pkglog.WithContext(r.Context())
+
+	authResult := "error"
+	defer func() {
+		metrics.AuthenticationInc("webserver", "oidc", authResult)
+	}()
+
+	p := oidc.Get(mox.Conf.Static.OIDC)
+	if p == nil {
+		http.Error(w, "500 - internal server error - single sign-on not configured"+recvid(r), http.StatusInternalServerError)
+		return
+	}
+
+	q := r.URL.Query()
+	state := q.Get("state")
+	stateCookie, _ := r.Cookie(webOIDCStateCookie)
+	http.SetCookie(w, &http.Cookie{
+		Name:     webOIDCStateCookie,
+		Path:     webOIDCCallbackPath,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   -1, // Delete cookie.
+	})
+	webOIDC.Lock()
+	login, ok := webOIDC.logins[state]
+	delete(webOIDC.logins, state)
+	webOIDC.Unlock()
+	if !ok || login.host != host || time.Now().After(login.expires) || stateCookie == nil || stateCookie.Value != state {
+		http.Error(w, "400 - bad request - unknown or expired single sign-on login, try again"+recvid(r), http.StatusBadRequest)
+		return
+	}
+	if errcode := q.Get("error"); errcode != "" {
+		authResult = "badcreds"
+		log.Info("single sign-on login failed at identity provider", slog.String("error", errcode), slog.String("description", q.Get("error_description")))
+		http.Error(w, "403 - forbidden - single sign-on login failed"+recvid(r), http.StatusForbidden)
+		return
+	}
+
+	email, err := p.Exchange(r.Context(), log, q.Get("code"), login.redirectURI, login.codeVerifier, login.nonce)
+	if err != nil && errors.Is(err, oidc.ErrToken) {
+		authResult = "badcreds"
+		log.Infox("single sign-on login not accepted", err)
+		http.Error(w, "403 - forbidden - single sign-on login not accepted"+recvid(r), http.StatusForbidden)
+		return
+	} else if err != nil {
+		log.Errorx("single sign-on login", err)
+		http.Error(w, "502 - bad gateway - single sign-on failed"+recvid(r), http.StatusBadGateway)
+		return
+	}
+	authResult = "ok"
+	log.Info("single sign-on login for webhandler", slog.String("email", email), slog.Any("host", host))
+
+	token := webRandom()
+	webOIDC.Lock()
+	now := time.Now()
+	for k, s := range webOIDC.sessions {
+		if now.After(s.expires) {
+			delete(webOIDC.sessions, k)
+		}
+	}
+	webOIDC.sessions[token] = webOIDCSession{host, email, now.Add(webOIDCSessionLifetime)}
+	webOIDC.Unlock()
+
+	// Without max-age, the cookie lasts for the browser session.
+	http.SetCookie(w, &http.Cookie{
+		Name:     webOIDCSessionCookie,
+		Value:    token,
+		Path:     "/",
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, login.returnPath, http.StatusSeeOther)
+}
@@ -0,0 +1,133 @@
+package http
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/ratelimit"
+)
+
+var metricWebBlocked = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "mox_httpserver_webhandler_blocked_total",
+		Help: "Requests for WebHandlers refused due to a rate limit or rule.",
+	},
+	[]string{
+		"handler", // Name from webhandler.
+		"reason",  // ratelimit, rule, bodysize
+	},
+)
+
+// Rate limiters for WebHandlers, keyed by domain and path regexp of the handler.
+// A limiter is replaced when the configuration of its handler changes.
+var webRateLimiters = struct {
+	sync.Mutex
+	limiters map[string]webRateLimiter
+}{limiters: map[string]webRateLimiter{}}
+
+type webRateLimiter struct {
+	config  config.WebRateLimit
+	limiter *ratelimit.Limiter
+}
+
+func webRateLimit(h config.WebHandler) (*ratelimit.Limiter, time.Duration) {
+	rl := *h.RateLimit
+	window := rl.Window
+	if window == 0 {
+		window = time.Minute
+	}
+
+	webRateLimiters.Lock()
+	defer webRateLimiters.Unlock()
+	key := h.Domain + " " + h.PathRegexp
+	if l, ok := webRateLimiters.limiters[key]; ok && l.config == rl {
+		return l.limiter, window
+	}
+	l := webRateLimiter{
+		rl,
+		&ratelimit.Limiter{
+			WindowLimits: []ratelimit.WindowLimit{
+				{
+					Window: window,
+					Limits: [...]int64{rl.Requests, 3 * rl.Requests, 9 * rl.Requests},
+				},
+			},
+		},
+	}
+	webRateLimiters.limiters[key] = l
+	return l.limiter, window
+}
+
+// webGuard applies the rate limit and rules of a WebHandler to a request. If the
+// request is refused, an error response is written and false returned. Otherwise
+// the request to pass to the handler is returned, with a limited body if a rule
+// configures a maximum body size.
+func webGuard(h config.WebHandler, w *loggingWriter, r *http.Request) (*http.Request, bool) {
+	log := pkglog.WithContext(r.Context())
+
+	blocked := func(reason string) {
+		w.Blocked = reason
+		metricWebBlocked.WithLabelValues(h.Name, reason).Inc()
+	}
+
+	if h.RateLimit != nil {
+		var remoteIP net.IP
+		if ipstr, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			remoteIP = net.ParseIP(ipstr)
+		}
+		limiter, window := webRateLimit(h)
+		if remoteIP != nil && !limiter.Add(remoteIP, time.Now(), 1) {
+			blocked("ratelimit")
+			log.Debug("webhandler rate limit reached", slog.Any("remoteip", remoteIP))
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", int64((window+time.Second-1)/time.Second)))
+			http.Error(w, "429 - too many requests"+recvid(r), http.StatusTooManyRequests)
+			return nil, false
+		}
+	}
+
+	for i, rule := range h.Rules {
+		if !webRuleMatch(rule, r) {
+			continue
+		}
+		if rule.MaxBodySize == 0 {
+			blocked("rule")
+			log.Debug("webhandler request blocked by rule", slog.Int("rule", i+1))
+			http.Error(w, "403 - forbidden"+recvid(r), http.StatusForbidden)
+			return nil, false
+		}
+		if r.ContentLength > rule.MaxBodySize {
+			blocked("bodysize")
+			log.Debug("webhandler request body too large", slog.Int("rule", i+1), slog.Int64("size", r.ContentLength))
+			http.Error(w, "413 - content too large"+recvid(r), http.StatusRequestEntityTooLarge)
+			return nil, false
+		}
+		xr := *r
+		xr.Body = http.MaxBytesReader(w, r.Body, rule.MaxBodySize)
+		return &xr, true
+	}
+	return r, true
+}
+
+// webRuleMatch returns whether the request matches all conditions of the rule.
+func webRuleMatch(rule config.WebRule, r *http.Request) bool {
+	if rule.Path != nil && !rule.Path.MatchString(r.URL.Path) {
+		return false
+	}
+	if len(rule.Methods) > 0 && !slices.ContainsFunc(rule.Methods, func(m string) bool { return strings.EqualFold(m, r.Method) }) {
+		return false
+	}
+	if rule.UserAgent != nil && !rule.UserAgent.MatchString(r.Header.Get("User-Agent")) {
+		return false
+	}
+	return true
+}
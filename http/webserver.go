@@ -18,14 +18,16 @@ import (
 	"net/http"
 	"net/http/httputil"
 	"net/textproto"
-	"net/url"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
+	"golang.org/x/net/http2"
+
 	"github.com/mjl-/mox/config"
 	"github.com/mjl-/mox/dns"
 	"github.com/mjl-/mox/mlog"
@@ -59,10 +61,18 @@ func WebHandle(w *loggingWriter, r *http.Request, host dns.Domain) (handled bool
 		u.Scheme = "https"
 		u.Host = to.Name()
 		w.Handler = "(domainredirect)"
+		w.AccessLog = true
 		http.Redirect(w, r, u.String(), http.StatusPermanentRedirect)
 		return true
 	}
 
+	if r.URL.Path == webOIDCCallbackPath && r.TLS != nil && webOIDCHasHandler(handlers, host) {
+		w.Handler = "(oidccallback)"
+		w.AccessLog = true
+		webOIDCCallback(w, r, host)
+		return true
+	}
+
 	for _, h := range handlers {
 		if host != h.DNSDomain {
 			continue
@@ -75,6 +85,9 @@ func WebHandle(w *loggingWriter, r *http.Request, host dns.Domain) (handled bool
 		e := loc[1]
 		path := r.URL.Path[s:e]
 
+		// Reset if no handler handles the request.
+		w.AccessLog = true
+
 		if r.TLS == nil && !h.DontRedirectPlainHTTP {
 			u := *r.URL
 			u.Scheme = "https"
@@ -85,6 +98,26 @@ func WebHandle(w *loggingWriter, r *http.Request, host dns.Domain) (handled bool
 			return true
 		}
 
+		r, ok := webGuard(h, w, r)
+		if !ok {
+			w.Handler = h.Name
+			return true
+		}
+
+		var user string
+		if h.Access != nil {
+			// Authentication can remove headers, keep them for other handlers.
+			xr := *r
+			xr.Header = r.Header.Clone()
+			var ok bool
+			user, ok = webAccess(h.Access, host, w, &xr)
+			if !ok {
+				w.Handler = h.Name
+				return true
+			}
+			r = &xr
+		}
+
 		// We don't want the loggingWriter to override the static handler's decisions to compress.
 		w.Compress = h.Compress
 		if h.WebStatic != nil && HandleStatic(h.WebStatic, h.Compress, w, r) {
@@ -95,12 +128,13 @@ func WebHandle(w *loggingWriter, r *http.Request, host dns.Domain) (handled bool
 			w.Handler = h.Name
 			return true
 		}
-		if h.WebForward != nil && HandleForward(h.WebForward, w, r, path) {
+		if h.WebForward != nil && HandleForward(h.WebForward, w, r, path, user) {
 			w.Handler = h.Name
 			return true
 		}
 	}
 	w.Compress = false
+	w.AccessLog = false
 	return false
 }
 
@@ -399,8 +433,9 @@ func HandleRedirect(h *config.WebRedirect, w http.ResponseWriter, r *http.Reques
 // HandleForward handles a request by forwarding it to another webserver and
 // passing the response on. I.e. a reverse proxy. It handles websocket
 // connections by monitoring the websocket handshake and then just passing along the
-// websocket frames.
-func HandleForward(h *config.WebForward, w http.ResponseWriter, r *http.Request, path string) (handled bool) {
+// websocket frames. If user is not empty, it is passed to the backend in the
+// X-Forwarded-User header.
+func HandleForward(h *config.WebForward, w http.ResponseWriter, r *http.Request, path, user string) (handled bool) {
 	log := func() mlog.Log {
 		return pkglog.WithContext(r.Context())
 	}
@@ -434,12 +469,23 @@ func HandleForward(h *config.WebForward, w http.ResponseWriter, r *http.Request,
 		proto = "https"
 	}
 	r.Header["X-Forwarded-Proto"] = []string{proto}
+	if user != "" {
+		r.Header["X-Forwarded-User"] = []string{user}
+	}
 	// note: We are not using "ws" or "wss" for websocket. The request we are
 	// forwarding is http(s), and we don't yet know if the backend even supports
 	// websockets.
 
 	// todo: add Forwarded header? is anyone using it?
 
+	for _, k := range h.RequestHeadersRemove {
+		r.Header.Del(k)
+	}
+	rewriteHeaders(r.Header, h.RequestHeaderRewrites)
+	for k, v := range h.RequestHeaders {
+		r.Header.Set(k, v)
+	}
+
 	// If we see an Upgrade: websocket, we're going to assume the client needs
 	// websocket and only attempt to talk websocket with the backend. If the backend
 	// doesn't do websocket, we'll send back a "bad request" response. For other values
@@ -461,7 +507,12 @@ func HandleForward(h *config.WebForward, w http.ResponseWriter, r *http.Request,
 
 	// ReverseProxy will append any remaining path to the configured target URL.
 	proxy := httputil.NewSingleHostReverseProxy(h.TargetURL)
+	proxy.Transport = forwardTransport(h)
 	proxy.FlushInterval = time.Duration(-1) // Flush after each write.
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		modifyResponseHeaders(h, resp.Header)
+		return nil
+	}
 	proxy.ErrorLog = golog.New(mlog.LogWriter(mlog.New("net/http/httputil", nil).WithContext(r.Context()), mlog.LevelDebug, "reverseproxy error"), "", 0)
 	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
 		if errors.Is(err, context.Canceled) {
@@ -566,7 +617,7 @@ func forwardWebsocket(h *config.WebForward, w http.ResponseWriter, r *http.Reque
 	freq.Proto = "HTTP/1.1"
 	freq.ProtoMajor = 1
 	freq.ProtoMinor = 1
-	fresp, beconn, err := websocketTransact(r.Context(), h.TargetURL, &freq)
+	fresp, beconn, err := websocketTransact(r.Context(), h, &freq)
 	if err != nil {
 		if errors.Is(err, errResponseNotWebsocket) {
 			http.Error(w, "400 - bad request - websocket not supported"+recvid(r), http.StatusBadRequest)
@@ -608,6 +659,7 @@ func forwardWebsocket(h *config.WebForward, w http.ResponseWriter, r *http.Reque
 	lw.WebsocketResponse = true
 	lw.setStatusCode(fresp.StatusCode)
 
+	modifyResponseHeaders(h, fresp.Header)
 	for k, v := range h.ResponseHeaders {
 		fresp.Header.Add(k, v)
 	}
@@ -660,11 +712,13 @@ func forwardWebsocket(h *config.WebForward, w http.ResponseWriter, r *http.Reque
 	return true
 }
 
-func websocketTransact(ctx context.Context, targetURL *url.URL, r *http.Request) (rresp *http.Response, rconn net.Conn, rerr error) {
+func websocketTransact(ctx context.Context, h *config.WebForward, r *http.Request) (rresp *http.Response, rconn net.Conn, rerr error) {
 	log := func() mlog.Log {
 		return pkglog.WithContext(r.Context())
 	}
 
+	targetURL := h.TargetURL
+
 	// Dial the backend, possibly doing TLS. We assume the net/http DefaultTransport is
 	// unmodified.
 	transport := http.DefaultTransport.(*http.Transport)
@@ -690,13 +744,23 @@ func websocketTransact(ctx context.Context, targetURL *url.URL, r *http.Request)
 		}
 	}
 	addr := net.JoinHostPort(host, port)
-	conn, err := transport.DialContext(r.Context(), "tcp", addr)
+	dialctx := r.Context()
+	if h.DialTimeout > 0 {
+		var cancel context.CancelFunc
+		dialctx, cancel = context.WithTimeout(dialctx, h.DialTimeout)
+		defer cancel()
+	}
+	conn, err := transport.DialContext(dialctx, "tcp", addr)
 	if err != nil {
 		return nil, nil, fmt.Errorf("dial: %w", err)
 	}
 	if targetURL.Scheme == "https" {
 		tlsconn := tls.Client(conn, transport.TLSClientConfig)
-		ctx, cancel := context.WithTimeout(r.Context(), transport.TLSHandshakeTimeout)
+		timeout := transport.TLSHandshakeTimeout
+		if h.DialTimeout > 0 {
+			timeout = h.DialTimeout
+		}
+		ctx, cancel := context.WithTimeout(dialctx, timeout)
 		defer cancel()
 		if err := tlsconn.HandshakeContext(ctx); err != nil {
 			return nil, nil, fmt.Errorf("tls handshake: %w", err)
@@ -709,8 +773,11 @@ func websocketTransact(ctx context.Context, targetURL *url.URL, r *http.Request)
 		}
 	}()
 
-	// todo: make timeout configurable?
-	if err := conn.SetDeadline(time.Now().Add(30 * time.Second)); err != nil {
+	timeout := 30 * time.Second
+	if h.ResponseTimeout > 0 {
+		timeout = h.ResponseTimeout
+	}
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
 		log().Check(err, "set deadline for websocket request to backend")
 	}
 
@@ -757,6 +824,147 @@ func websocketTransact(ctx context.Context, targetURL *url.URL, r *http.Request)
 	return resp, websocketConn{io.MultiReader(bytes.NewReader(buf), conn), conn}, nil
 }
 
+// rewriteHeaders replaces values of headers through regular expressions.
+func rewriteHeaders(hdr http.Header, rewrites []config.WebHeaderRewrite) {
+	for _, hr := range rewrites {
+		k := textproto.CanonicalMIMEHeaderKey(hr.Header)
+		for i, v := range hdr[k] {
+			hdr[k][i] = hr.Value.ReplaceAllString(v, hr.Replace)
+		}
+	}
+}
+
+// modifyResponseHeaders removes and rewrites headers of a response from a backend.
+// ResponseHeaders are added separately, they also apply to our own error
+// responses.
+func modifyResponseHeaders(h *config.WebForward, hdr http.Header) {
+	for _, k := range h.ResponseHeadersRemove {
+		hdr.Del(k)
+	}
+	rewriteHeaders(hdr, h.ResponseHeaderRewrites)
+}
+
+// forwardTransportKey identifies the settings for a transport to a backend.
+// Transports are shared between WebForwards with the same settings, and kept
+// across config changes, so connections to backends can be reused.
+type forwardTransportKey struct {
+	http2           bool
+	h2c             bool // HTTP/2 without TLS.
+	dialTimeout     time.Duration
+	responseTimeout time.Duration
+}
+
+var forwardTransports = struct {
+	sync.Mutex
+	m map[forwardTransportKey]http.RoundTripper
+}{m: map[forwardTransportKey]http.RoundTripper{}}
+
+// forwardTransport returns the transport for making requests to the backend of h.
+func forwardTransport(h *config.WebForward) http.RoundTripper {
+	if !h.HTTP2 && h.DialTimeout == 0 && h.ResponseTimeout == 0 {
+		return http.DefaultTransport
+	}
+
+	key := forwardTransportKey{h.HTTP2, h.HTTP2 && h.TargetURL.Scheme == "http", h.DialTimeout, h.ResponseTimeout}
+	forwardTransports.Lock()
+	defer forwardTransports.Unlock()
+	if rt, ok := forwardTransports.m[key]; ok {
+		return rt
+	}
+
+	dialTimeout := 30 * time.Second
+	if h.DialTimeout > 0 {
+		dialTimeout = h.DialTimeout
+	}
+	dialer := &net.Dialer{Timeout: dialTimeout, KeepAlive: 30 * time.Second}
+
+	var rt http.RoundTripper
+	if !h.HTTP2 {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.DialContext = dialer.DialContext
+		if h.DialTimeout > 0 {
+			t.TLSHandshakeTimeout = h.DialTimeout
+		}
+		t.ResponseHeaderTimeout = h.ResponseTimeout
+		rt = t
+	} else {
+		t := &http2.Transport{
+			// Detect backends that went away, for long-running requests like gRPC streams.
+			ReadIdleTimeout: 30 * time.Second,
+		}
+		if key.h2c {
+			// With AllowHTTP, the TLS dial function is used for plain connections too.
+			t.AllowHTTP = true
+			t.DialTLSContext = func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, addr)
+			}
+		} else {
+			t.DialTLSContext = func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+				ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+				defer cancel()
+				d := tls.Dialer{NetDialer: dialer, Config: cfg}
+				return d.DialContext(ctx, network, addr)
+			}
+		}
+		rt = t
+		if h.ResponseTimeout > 0 {
+			rt = responseTimeoutTransport{t, h.ResponseTimeout}
+		}
+	}
+	forwardTransports.m[key] = rt
+	return rt
+}
+
+// errResponseTimeout is returned when a backend does not send response headers in
+// time. It is a timeout error for os.IsTimeout.
+var errResponseTimeout = responseTimeoutError{}
+
+type responseTimeoutError struct{}
+
+func (responseTimeoutError) Error() string {
+	return "timeout waiting for response headers from backend"
+}
+
+func (responseTimeoutError) Timeout() bool {
+	return true
+}
+
+// responseTimeoutTransport limits the duration until response headers are
+// received, for transports that don't implement such a timeout themselves.
+type responseTimeoutTransport struct {
+	rt      http.RoundTripper
+	timeout time.Duration
+}
+
+func (t responseTimeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	timer := time.AfterFunc(t.timeout, cancel)
+	resp, err := t.rt.RoundTrip(req.WithContext(ctx))
+	if !timer.Stop() {
+		if err == nil {
+			resp.Body.Close()
+		}
+		return nil, errResponseTimeout
+	} else if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = cancelReadCloser{resp.Body, cancel}
+	return resp, nil
+}
+
+// cancelReadCloser cancels the request context when the response body is closed.
+type cancelReadCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c cancelReadCloser) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
 // A net.Conn but with reads coming from an io multireader (due to buffered reader
 // needed for http.ReadResponse).
 type websocketConn struct {
@@ -2,6 +2,7 @@ package http
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net"
@@ -10,11 +11,17 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"testing"
+	"time"
 
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/net/http2"
 	"golang.org/x/net/websocket"
 
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/dns"
 	"github.com/mjl-/mox/mox-"
 )
 
@@ -156,6 +163,282 @@ func TestWebserver(t *testing.T) {
 	}
 }
 
+// Test forwarding with HTTP/2 without TLS to a backend, with header rewrites and
+// response timeouts.
+func TestWebForwardHTTP2(t *testing.T) {
+	var handler http.Handler // Active handler during test.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	tcheck(t, err, "listen")
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go (&http2.Server{}).ServeConn(conn, &http2.ServeConnOpts{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				handler.ServeHTTP(w, r)
+			})})
+		}
+	}()
+
+	h := &config.WebForward{
+		URL:                    "http://" + ln.Addr().String(),
+		HTTP2:                  true,
+		ResponseTimeout:        time.Second,
+		RequestHeaders:         map[string]string{"X-Set": "set"},
+		RequestHeadersRemove:   []string{"cookie"},
+		RequestHeaderRewrites:  []config.WebHeaderRewrite{{Header: "x-rewrite", ValueRegexp: "^a(.)$", Replace: "b$1"}},
+		ResponseHeaders:        map[string]string{"X-Test": "mox"},
+		ResponseHeadersRemove:  []string{"Server"},
+		ResponseHeaderRewrites: []config.WebHeaderRewrite{{Header: "Location", ValueRegexp: "^http://internal.example/", Replace: "https://mox.example/"}},
+	}
+	h.TargetURL, err = url.Parse(h.URL)
+	tcheck(t, err, "parse url")
+	for _, l := range [][]config.WebHeaderRewrite{h.RequestHeaderRewrites, h.ResponseHeaderRewrites} {
+		for i := range l {
+			l[i].Value = regexp.MustCompile(l[i].ValueRegexp)
+		}
+	}
+
+	test := func(reqhdrs map[string]string, expCode int, expHeaders map[string]string) {
+		t.Helper()
+
+		req := httptest.NewRequest("GET", "https://mox.example/grpc", nil)
+		for k, v := range reqhdrs {
+			req.Header.Add(k, v)
+		}
+		rw := httptest.NewRecorder()
+		HandleForward(h, rw, req, "/", "")
+		resp := rw.Result()
+		if resp.StatusCode != expCode {
+			t.Fatalf("got statuscode %d, expected %d", resp.StatusCode, expCode)
+		}
+		for k, v := range expHeaders {
+			if xv := resp.Header.Get(k); xv != v {
+				t.Fatalf("got %q for header %q, expected %q", xv, k, v)
+			}
+		}
+	}
+
+	handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 || r.Header.Get("X-Set") != "set" || r.Header.Get("Cookie") != "" || r.Header.Get("X-Rewrite") != "bc" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Server", "backend/1.0")
+		w.Header().Set("Location", "http://internal.example/path")
+		w.WriteHeader(http.StatusFound)
+	})
+	test(map[string]string{"Cookie": "secret", "X-Rewrite": "ac", "X-Set": "client"}, http.StatusFound, map[string]string{
+		"X-Test":   "mox",
+		"Server":   "",
+		"Location": "https://mox.example/path",
+	})
+
+	// Backend that doesn't respond in time.
+	handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	})
+	h.ResponseTimeout = 10 * time.Millisecond
+	test(nil, http.StatusGatewayTimeout, map[string]string{"X-Test": "mox"})
+}
+
+// Test access control for WebHandlers with IP lists, basic authentication with an
+// htpasswd file, and existing OIDC sessions.
+func TestWebAccess(t *testing.T) {
+	mox.LimitersInit()
+	webBadAuthDelay = 0
+	host := dns.Domain{ASCII: "mox.example"}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("test1234"), bcrypt.MinCost)
+	tcheck(t, err, "bcrypt")
+	htpasswdPath := filepath.Join(t.TempDir(), "htpasswd")
+	err = os.WriteFile(htpasswdPath, []byte("# comment\nmjl:"+strings.Replace(string(hash), "$2a$", "$2y$", 1)+"\nother:{SHA}bogus\n"), 0660)
+	tcheck(t, err, "write htpasswd")
+
+	_, net1, _ := net.ParseCIDR("192.0.2.0/24")
+	_, net2, _ := net.ParseCIDR("192.0.2.2/32")
+
+	test := func(wa *config.WebAccess, remoteAddr string, prep func(r *http.Request), expCode int, expUser string) *http.Request {
+		t.Helper()
+
+		req := httptest.NewRequest("GET", "https://mox.example/", nil)
+		req.RemoteAddr = remoteAddr
+		if prep != nil {
+			prep(req)
+		}
+		rw := httptest.NewRecorder()
+		w := &loggingWriter{W: rw, Start: time.Now(), R: req}
+		user, ok := webAccess(wa, host, w, req)
+		if expCode == 0 && !ok {
+			t.Fatalf("access refused with status %d, expected access", rw.Code)
+		} else if expCode != 0 && (ok || rw.Code != expCode) {
+			t.Fatalf("got access %v, status %d, expected status %d", ok, rw.Code, expCode)
+		}
+		if user != expUser {
+			t.Fatalf("got user %q, expected %q", user, expUser)
+		}
+		return req
+	}
+
+	// IP allow and deny lists.
+	ipAccess := &config.WebAccess{IPAllowNets: []net.IPNet{*net1}, IPDenyNets: []net.IPNet{*net2}}
+	test(ipAccess, "192.0.2.1:1234", nil, 0, "")
+	test(ipAccess, "192.0.2.2:1234", nil, http.StatusForbidden, "")
+	test(ipAccess, "198.51.100.1:1234", nil, http.StatusForbidden, "")
+
+	// Basic authentication with htpasswd file.
+	basicAccess := &config.WebAccess{BasicAuth: &config.WebBasicAuth{HTPasswdPath: htpasswdPath}}
+	auth := func(username, password string) func(r *http.Request) {
+		return func(r *http.Request) {
+			r.SetBasicAuth(username, password)
+		}
+	}
+	test(basicAccess, "192.0.2.10:1234", nil, http.StatusUnauthorized, "")
+	test(basicAccess, "192.0.2.10:1234", auth("mjl", "bad"), http.StatusUnauthorized, "")
+	test(basicAccess, "192.0.2.10:1234", auth("other", "bogus"), http.StatusUnauthorized, "")
+	test(basicAccess, "192.0.2.10:1234", auth("unknown", "test1234"), http.StatusUnauthorized, "")
+	req := test(basicAccess, "192.0.2.10:1234", auth("mjl", "test1234"), 0, "mjl")
+	if req.Header.Get("Authorization") != "" {
+		t.Fatalf("authorization header not removed")
+	}
+	// Cached verification.
+	test(basicAccess, "192.0.2.10:1234", auth("mjl", "test1234"), 0, "mjl")
+	test(basicAccess, "192.0.2.10:1234", auth("mjl", "test12345"), http.StatusUnauthorized, "")
+
+	// Authentication only over https.
+	test(basicAccess, "192.0.2.10:1234", func(r *http.Request) { r.TLS = nil }, http.StatusForbidden, "")
+
+	// OIDC sessions. Without session, POST requests cannot be redirected for login.
+	oidcAccess := &config.WebAccess{OIDC: &config.WebOIDCAuth{Domains: []string{"mox.example"}}}
+	test(oidcAccess, "192.0.2.10:1234", func(r *http.Request) { r.Method = "POST" }, http.StatusUnauthorized, "")
+
+	webOIDC.Lock()
+	webOIDC.sessions["good"] = webOIDCSession{host, "mjl@mox.example", time.Now().Add(time.Hour)}
+	webOIDC.sessions["other"] = webOIDCSession{host, "mjl@other.example", time.Now().Add(time.Hour)}
+	webOIDC.sessions["otherhost"] = webOIDCSession{dns.Domain{ASCII: "other.example"}, "mjl@mox.example", time.Now().Add(time.Hour)}
+	webOIDC.Unlock()
+	session := func(token string) func(r *http.Request) {
+		return func(r *http.Request) {
+			r.Method = "POST"
+			r.AddCookie(&http.Cookie{Name: "backend", Value: "x"})
+			r.AddCookie(&http.Cookie{Name: webOIDCSessionCookie, Value: token})
+		}
+	}
+	req = test(oidcAccess, "192.0.2.10:1234", session("good"), 0, "mjl@mox.example")
+	if _, err := req.Cookie(webOIDCSessionCookie); err == nil {
+		t.Fatalf("session cookie not removed")
+	} else if _, err := req.Cookie("backend"); err != nil {
+		t.Fatalf("other cookie removed")
+	}
+	test(oidcAccess, "192.0.2.10:1234", session("other"), http.StatusForbidden, "")
+	test(oidcAccess, "192.0.2.10:1234", session("otherhost"), http.StatusUnauthorized, "")
+
+	wo := &config.WebOIDCAuth{Emails: []string{"mjl@other.example"}, Domains: []string{"mox.example"}}
+	if !webOIDCAllowed(wo, "mjl@other.example") || !webOIDCAllowed(wo, "x@mox.example") || webOIDCAllowed(wo, "x@other.example") {
+		t.Fatalf("bad result for allowed oidc users")
+	}
+}
+
+// Test rate limits and rules for blocking requests and limiting body sizes.
+func TestWebGuard(t *testing.T) {
+	h := config.WebHandler{
+		Name:       "guard",
+		Domain:     "mox.example",
+		PathRegexp: "^/",
+		RateLimit:  &config.WebRateLimit{Requests: 3},
+		Rules: []config.WebRule{
+			{Path: regexp.MustCompile(`^/wp-(admin|login)`)},
+			{UserAgent: regexp.MustCompile("(?i)(sqlmap|nikto)")},
+			{Methods: []string{"post"}, MaxBodySize: 10},
+		},
+	}
+
+	test := func(method, path, userAgent, body string, remoteAddr string, expCode int, expBlocked string) *http.Request {
+		t.Helper()
+
+		req := httptest.NewRequest(method, "https://mox.example"+path, strings.NewReader(body))
+		req.RemoteAddr = remoteAddr
+		if userAgent != "" {
+			req.Header.Set("User-Agent", userAgent)
+		}
+		rw := httptest.NewRecorder()
+		w := &loggingWriter{W: rw, Start: time.Now(), R: req}
+		nreq, ok := webGuard(h, w, req)
+		if expCode == 0 && !ok {
+			t.Fatalf("request refused with status %d, expected pass", rw.Code)
+		} else if expCode != 0 && (ok || rw.Code != expCode) {
+			t.Fatalf("got pass %v, status %d, expected status %d", ok, rw.Code, expCode)
+		}
+		if w.Blocked != expBlocked {
+			t.Fatalf("got blocked %q, expected %q", w.Blocked, expBlocked)
+		}
+		return nreq
+	}
+
+	test("GET", "/wp-login.php", "", "", "192.0.2.1:1234", http.StatusForbidden, "rule")
+	test("GET", "/", "Mozilla/5.0 SQLMap", "", "192.0.2.1:1234", http.StatusForbidden, "rule")
+	test("POST", "/", "", "0123456789a", "192.0.2.1:1234", http.StatusRequestEntityTooLarge, "bodysize")
+	// Rate limit reached, also for other IPs in the same /26.
+	test("GET", "/", "", "", "192.0.2.1:1234", http.StatusTooManyRequests, "ratelimit")
+	test("GET", "/", "", "", "192.0.2.2:1234", 0, "")
+	test("GET", "/", "", "", "192.0.2.2:1234", 0, "")
+
+	// Request body is limited when size is unknown.
+	req := test("POST", "/", "", "", "192.0.2.200:1234", 0, "")
+	req.Body = io.NopCloser(strings.NewReader("0123456789a"))
+	req.ContentLength = -1
+	rw := httptest.NewRecorder()
+	req, ok := webGuard(h, &loggingWriter{W: rw, Start: time.Now(), R: req}, req)
+	if !ok {
+		t.Fatalf("request refused, expected pass")
+	}
+	if _, err := io.ReadAll(req.Body); err == nil {
+		t.Fatalf("reading large body succeeded, expected error")
+	}
+
+	// New limiter when configuration changes.
+	h.RateLimit = &config.WebRateLimit{Requests: 10}
+	test("GET", "/", "", "", "192.0.2.1:1234", 0, "")
+}
+
+func TestAccessLog(t *testing.T) {
+	dir := t.TempDir()
+	al := &config.WebserverAccessLog{Path: filepath.Join(dir, "access.log"), MaxSize: 300, MaxFiles: 2}
+
+	line := accessLogLine{Time: time.Now(), Handler: "test", RemoteIP: "192.0.2.1", Method: "GET", Host: "mox.example", URL: "/", StatusCode: 200}
+	for i := 0; i < 10; i++ {
+		err := accessLogWrite(al, line)
+		tcheck(t, err, "write access log")
+	}
+	accessLog.Lock()
+	err := accessLog.f.Close()
+	accessLog.f = nil
+	accessLog.Unlock()
+	tcheck(t, err, "close access log")
+
+	for _, name := range []string{"access.log", "access.log.1", "access.log.2"} {
+		buf, err := os.ReadFile(filepath.Join(dir, name))
+		tcheck(t, err, "read access log")
+		if len(buf) == 0 || len(buf) > 300 {
+			t.Fatalf("got size %d for %s, expected 1..300", len(buf), name)
+		}
+		var l accessLogLine
+		err = json.Unmarshal(bytes.SplitN(buf, []byte("\n"), 2)[0], &l)
+		tcheck(t, err, "parse access log line")
+		if l.Handler != "test" || l.StatusCode != 200 {
+			t.Fatalf("got access log line %#v, expected handler and status code", l)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "access.log.3")); err == nil {
+		t.Fatalf("access.log.3 exists, expected at most 2 rotated files")
+	}
+}
+
 func TestWebsocket(t *testing.T) {
 	os.RemoveAll("../testdata/websocket/data")
 	mox.ConfigStaticPath = filepath.FromSlash("../testdata/websocket/mox.conf")
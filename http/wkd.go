@@ -0,0 +1,115 @@
+package http
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/autocrypt"
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/store"
+)
+
+// wkdHandle serves OpenPGP public keys for the Web Key Directory, with the
+// "advanced method" at openpgpkey.<domain>, draft-koch-openpgp-webkey-service
+// section 3.1. Paths are /.well-known/openpgpkey/<domain>/hu/<hash> and
+// /.well-known/openpgpkey/<domain>/policy.
+func wkdHandle(w http.ResponseWriter, r *http.Request) {
+	log := func() mlog.Log {
+		return pkglog.WithContext(r.Context())
+	}
+
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "405 - method not allowed - get or head required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	host := strings.ToLower(r.Host)
+	if nhost, _, err := net.SplitHostPort(host); err == nil {
+		// Only relevant for when host has a port.
+		host = nhost
+	}
+	host = strings.TrimPrefix(host, "openpgpkey.")
+
+	t := strings.Split(strings.TrimPrefix(r.URL.Path, "/.well-known/openpgpkey/"), "/")
+	if len(t) < 2 || !strings.EqualFold(t[0], host) {
+		http.NotFound(w, r)
+		return
+	}
+	domain, err := dns.ParseDomain(t[0])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if dc, ok := mox.Conf.Domain(domain); !ok || dc.ReportsOnly {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Keys can be fetched from anywhere.
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if len(t) == 2 && t[1] == "policy" {
+		// Empty policy, we don't support the submission protocol.
+		w.Header().Set("Content-Type", "text/plain")
+		return
+	} else if len(t) != 3 || t[1] != "hu" {
+		http.NotFound(w, r)
+		return
+	}
+
+	// We compare the hash against all localparts of the domain, the "l" parameter is
+	// optional.
+	localparts, _ := mox.Conf.DomainLocalparts(domain)
+	var accountName string
+	var addr smtp.Address
+	for lp, accName := range localparts {
+		if lp != "" && autocrypt.WKDHash(lp) == t[2] {
+			accountName = accName
+			addr = smtp.Address{Localpart: smtp.Localpart(lp), Domain: domain}
+			break
+		}
+	}
+	if accountName == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	acc, err := store.OpenAccount(log(), accountName)
+	if err != nil {
+		log().Errorx("open account for wkd request", err, slog.String("account", accountName))
+		http.Error(w, "500 - internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		err := acc.Close()
+		log().Check(err, "closing account")
+	}()
+
+	pk, err := acc.PGPKeyOwn(r.Context(), addr.String())
+	if err == bstore.ErrAbsent || err == nil && !pk.WKDPublish {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		log().Errorx("looking up openpgp key for wkd request", err, slog.Any("address", addr))
+		http.Error(w, "500 - internal server error", http.StatusInternalServerError)
+		return
+	}
+	_, buf, err := autocrypt.Unarmor(pk.PublicKey)
+	if err != nil {
+		log().Errorx("unarmoring openpgp key for wkd request", err, slog.Any("address", addr))
+		http.Error(w, "500 - internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Cache-Control", "no-cache, max-age=0")
+	if r.Method == "GET" {
+		_, _ = w.Write(buf)
+	}
+}
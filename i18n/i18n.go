@@ -0,0 +1,153 @@
+// Package i18n has translations for the web interfaces (webmail, webaccount,
+// webadmin) and for messages composed by mox, such as delivery status
+// notifications.
+//
+// Translations are in language packs, JSON files in lang/ named after the
+// language code, e.g. "nl.json". A pack maps the English text to the translated
+// text. Text is looked up by its English version, so missing translations fall
+// back to English. Text can have placeholders "{0}", "{1}", etc., that are
+// replaced by parameters. New languages are added by adding a pack.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/mjl-/mox/mlog"
+)
+
+//go:embed lang/*.json
+var langFS embed.FS
+
+// Language is a language with a translation pack, or English.
+type Language struct {
+	Code string // E.g. "nl", lower case.
+	Name string // In the language itself, e.g. "Nederlands".
+}
+
+type pack struct {
+	Name         string            // Name of the language in the language itself.
+	Translations map[string]string // From English to translated text.
+}
+
+// Languages with translations, English first, then sorted by code.
+var Languages = []Language{{"en", "English"}}
+
+var packs = map[string]pack{}
+
+func init() {
+	l, err := langFS.ReadDir("lang")
+	if err != nil {
+		panic(fmt.Sprintf("reading language packs: %v", err))
+	}
+	for _, e := range l {
+		buf, err := langFS.ReadFile("lang/" + e.Name())
+		if err != nil {
+			panic(fmt.Sprintf("reading language pack: %v", err))
+		}
+		var p pack
+		if err := json.Unmarshal(buf, &p); err != nil {
+			panic(fmt.Sprintf("parsing language pack %s: %v", e.Name(), err))
+		}
+		code := strings.TrimSuffix(e.Name(), path.Ext(e.Name()))
+		packs[code] = p
+		Languages = append(Languages, Language{code, p.Name})
+	}
+	slices.SortFunc(Languages[1:], func(a, b Language) int {
+		return strings.Compare(a.Code, b.Code)
+	})
+}
+
+// Known returns whether code is a known language, "en" or one with a
+// translation pack.
+func Known(code string) bool {
+	_, ok := packs[code]
+	return ok || code == "en"
+}
+
+// Translate returns the translation of English text s for language lang, with
+// placeholders "{0}", "{1}", etc. replaced by args. For unknown languages and
+// missing translations, the English text is used.
+func Translate(lang, s string, args ...any) string {
+	if t, ok := packs[lang].Translations[s]; ok && t != "" {
+		s = t
+	}
+	for i, a := range args {
+		s = strings.ReplaceAll(s, "{"+strconv.Itoa(i)+"}", fmt.Sprint(a))
+	}
+	return s
+}
+
+// Match returns the first known language for the preferences, each an
+// Accept-Language header value or a comma-separated list of language tags, e.g.
+// "nl-BE, de;q=0.8". Region subtags are ignored. If no language is known, "en" is
+// returned.
+func Match(preferences ...string) string {
+	for _, pref := range preferences {
+		type tag struct {
+			code string
+			q    float64
+		}
+		var tags []tag
+		for _, t := range strings.Split(pref, ",") {
+			t, params, _ := strings.Cut(strings.TrimSpace(t), ";")
+			q := 1.0
+			if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					q = f
+				}
+			}
+			code, _, _ := strings.Cut(t, "-")
+			tags = append(tags, tag{strings.ToLower(code), q})
+		}
+		slices.SortStableFunc(tags, func(a, b tag) int {
+			if a.q > b.q {
+				return -1
+			} else if a.q < b.q {
+				return 1
+			}
+			return 0
+		})
+		for _, t := range tags {
+			if t.q > 0 && Known(t.code) {
+				return t.code
+			}
+		}
+	}
+	return "en"
+}
+
+// ServeJSON serves the language pack for the web interfaces, for the language
+// matched from the "lang" query string parameter (comma-separated language
+// codes), falling back to the Accept-Language header. The response is a JSON
+// object with fields Language (the matched code), Languages (all known
+// languages) and Translations.
+func ServeJSON(log mlog.Log, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "405 - method not allowed - use get", http.StatusMethodNotAllowed)
+		return
+	}
+
+	lang := Match(r.URL.Query().Get("lang"), r.Header.Get("Accept-Language"))
+	resp := struct {
+		Language     string
+		Languages    []Language
+		Translations map[string]string
+	}{lang, Languages, packs[lang].Translations}
+	if resp.Translations == nil {
+		resp.Translations = map[string]string{}
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "application/json; charset=utf-8")
+	h.Set("Cache-Control", "no-cache, max-age=0")
+	h.Add("Vary", "Accept-Language")
+	err := json.NewEncoder(w).Encode(resp)
+	log.Check(err, "writing language pack")
+}
@@ -0,0 +1,91 @@
+package i18n
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/mjl-/mox/mlog"
+)
+
+func tcompare(t *testing.T, got, exp any) {
+	t.Helper()
+	if got != exp {
+		t.Fatalf("got %v, expected %v", got, exp)
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	tcompare(t, Known("en"), true)
+	tcompare(t, Known("nl"), true)
+	tcompare(t, Known("xx"), false)
+	tcompare(t, Languages[0].Code, "en")
+
+	tcompare(t, Translate("nl", "Password"), "Wachtwoord")
+	tcompare(t, Translate("en", "Password"), "Password")
+	tcompare(t, Translate("", "Password"), "Password")
+	tcompare(t, Translate("nl", "not translated"), "not translated")
+	tcompare(t, Translate("nl", "{0} seconds", 10), "10 seconden")
+	tcompare(t, Translate("xx", "{0} seconds", 10), "10 seconds")
+
+	tcompare(t, Match(""), "en")
+	tcompare(t, Match("nl-BE"), "nl")
+	tcompare(t, Match("xx, de;q=0.5, nl;q=0.8"), "nl")
+	tcompare(t, Match("nl;q=0, de"), "de")
+	tcompare(t, Match("", "xx", "DE-AT,en"), "de")
+	tcompare(t, Match("en-GB", "nl"), "en")
+}
+
+// All translations must have the same placeholders as the English text.
+func TestPacks(t *testing.T) {
+	placeholders := regexp.MustCompile(`\{[0-9]+\}`)
+	for code, p := range packs {
+		if p.Name == "" {
+			t.Fatalf("pack %s: missing name", code)
+		}
+		for en, s := range p.Translations {
+			exp := placeholders.FindAllString(en, -1)
+			got := placeholders.FindAllString(s, -1)
+			if len(exp) != len(got) {
+				t.Fatalf("pack %s: translation %q has placeholders %v, expected %v", code, s, got, exp)
+			}
+		}
+	}
+}
+
+func TestServeJSON(t *testing.T) {
+	log := mlog.New("i18n", nil)
+
+	r := httptest.NewRequest("GET", "/i18n.json?lang=xx,nl-NL", nil)
+	w := httptest.NewRecorder()
+	ServeJSON(log, w, r)
+	tcompare(t, w.Code, 200)
+	var resp struct {
+		Language     string
+		Languages    []Language
+		Translations map[string]string
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("parsing response: %v", err)
+	}
+	tcompare(t, resp.Language, "nl")
+	tcompare(t, len(resp.Languages), len(Languages))
+	tcompare(t, resp.Translations["Password"], "Wachtwoord")
+
+	// Fall back to Accept-Language.
+	r = httptest.NewRequest("GET", "/i18n.json", nil)
+	r.Header.Set("Accept-Language", "de-DE,de;q=0.9")
+	w = httptest.NewRecorder()
+	ServeJSON(log, w, r)
+	resp.Translations = nil
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("parsing response: %v", err)
+	}
+	tcompare(t, resp.Language, "de")
+
+	r = httptest.NewRequest("POST", "/i18n.json", nil)
+	w = httptest.NewRecorder()
+	ServeJSON(log, w, r)
+	tcompare(t, w.Code, 405)
+}
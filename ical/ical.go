@@ -0,0 +1,353 @@
+// Package ical parses and writes iCalendar objects, RFC 5545, as used for
+// calendar invitations in email (iMIP, RFC 6047) and for CalDAV.
+//
+// An object is kept as a tree of components with their properties, so
+// properties and components that mox does not interpret are preserved when an
+// object is modified and written again.
+package ical
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/mjl-/mox/internal/contentline"
+)
+
+var ErrSyntax = errors.New("icalendar syntax error")
+
+// Param is a property parameter, e.g. PARTSTAT=ACCEPTED.
+type Param = contentline.Param
+
+// Prop is a property (content line) of a component.
+type Prop struct {
+	Name   string // Upper case, e.g. SUMMARY, DTSTART, ATTENDEE.
+	Params []Param
+	Value  string // Raw value, still escaped. See Text for unescaping.
+}
+
+// Text returns the value unescaped as text, with \n, \, and \; resolved.
+func (p Prop) Text() string {
+	return contentline.Unescape(p.Value)
+}
+
+// Param returns the first value of the named parameter, or the empty string.
+func (p Prop) Param(name string) string {
+	return contentline.ParamValue(p.Params, name)
+}
+
+// SetParam sets the named parameter to a single value, replacing an existing
+// parameter.
+func (p *Prop) SetParam(name, value string) {
+	for i, pa := range p.Params {
+		if strings.EqualFold(pa.Name, name) {
+			p.Params[i] = Param{Name: pa.Name, Values: []string{value}}
+			return
+		}
+	}
+	p.Params = append(p.Params, Param{Name: strings.ToUpper(name), Values: []string{value}})
+}
+
+// Address returns the email address of a "mailto:" value, as used in ORGANIZER
+// and ATTENDEE properties. The empty string is returned for other values.
+func (p Prop) Address() string {
+	if len(p.Value) > len("mailto:") && strings.EqualFold(p.Value[:len("mailto:")], "mailto:") {
+		return p.Value[len("mailto:"):]
+	}
+	return ""
+}
+
+// Time parses a DATE or DATE-TIME value, as used in DTSTART and DTEND. For
+// values with a TZID parameter, the time zone is looked up in the time zone
+// database, and if not found in the VTIMEZONE components of cal, using the
+// offset of its first STANDARD definition. If the zone is unknown, UTC is used.
+// allDay is set for DATE values.
+func (p Prop) Time(cal Component) (t time.Time, allDay bool, rerr error) {
+	v := p.Value
+	if strings.EqualFold(p.Param("VALUE"), "DATE") || len(v) == len("20060102") {
+		t, err := time.ParseInLocation("20060102", v, time.UTC)
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("%w: bad date %q", ErrSyntax, v)
+		}
+		return t, true, nil
+	}
+	if strings.HasSuffix(v, "Z") {
+		t, err := time.Parse("20060102T150405Z", v)
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("%w: bad date-time %q", ErrSyntax, v)
+		}
+		return t, false, nil
+	}
+	loc := time.UTC
+	if tzid := p.Param("TZID"); tzid != "" {
+		loc = zone(cal, tzid)
+	}
+	t, err := time.ParseInLocation("20060102T150405", v, loc)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("%w: bad date-time %q", ErrSyntax, v)
+	}
+	return t, false, nil
+}
+
+// zone returns the location for a TZID.
+func zone(cal Component, tzid string) *time.Location {
+	if loc, err := time.LoadLocation(tzid); err == nil {
+		return loc
+	}
+	for _, tz := range cal.Components {
+		if tz.Name != "VTIMEZONE" || tz.Text("TZID") != tzid {
+			continue
+		}
+		for _, std := range tz.Components {
+			if std.Name != "STANDARD" {
+				continue
+			}
+			if off, ok := parseOffset(std.Text("TZOFFSETTO")); ok {
+				return time.FixedZone(tzid, off)
+			}
+		}
+	}
+	return time.UTC
+}
+
+// parseOffset parses a UTC offset like "+0100" or "-053000" into seconds.
+func parseOffset(s string) (int, bool) {
+	if len(s) != 5 && len(s) != 7 || s[0] != '+' && s[0] != '-' {
+		return 0, false
+	}
+	var n [3]int
+	for i := 0; i < (len(s)-1)/2; i++ {
+		a, b := s[1+2*i], s[2+2*i]
+		if a < '0' || a > '9' || b < '0' || b > '9' {
+			return 0, false
+		}
+		n[i] = int(a-'0')*10 + int(b-'0')
+	}
+	off := n[0]*3600 + n[1]*60 + n[2]
+	if s[0] == '-' {
+		off = -off
+	}
+	return off, true
+}
+
+// Component is a component, e.g. VCALENDAR, VEVENT or VTIMEZONE, with its
+// properties and nested components. The BEGIN and END properties are not
+// included in Props.
+type Component struct {
+	Name       string // Upper case.
+	Props      []Prop
+	Components []Component
+}
+
+// Get returns the first property with name, and whether it was found.
+func (c Component) Get(name string) (Prop, bool) {
+	for _, p := range c.Props {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Prop{}, false
+}
+
+// Text returns the unescaped value of the first property with name, or the
+// empty string if absent.
+func (c Component) Text(name string) string {
+	p, _ := c.Get(name)
+	return p.Text()
+}
+
+// All returns all properties with name.
+func (c Component) All(name string) []Prop {
+	var l []Prop
+	for _, p := range c.Props {
+		if p.Name == name {
+			l = append(l, p)
+		}
+	}
+	return l
+}
+
+// Set replaces the first property with the same name, or adds it at the end.
+func (c *Component) Set(p Prop) {
+	for i := range c.Props {
+		if c.Props[i].Name == p.Name {
+			c.Props[i] = p
+			return
+		}
+	}
+	c.Props = append(c.Props, p)
+}
+
+// Remove removes all properties with name.
+func (c *Component) Remove(name string) {
+	var l []Prop
+	for _, p := range c.Props {
+		if p.Name != name {
+			l = append(l, p)
+		}
+	}
+	c.Props = l
+}
+
+// Events returns the VEVENT components.
+func (c Component) Events() []Component {
+	var l []Component
+	for _, sc := range c.Components {
+		if sc.Name == "VEVENT" {
+			l = append(l, sc)
+		}
+	}
+	return l
+}
+
+// Escape escapes a text value for use in a property.
+func Escape(s string) string {
+	return contentline.Escape(s)
+}
+
+// FormatTime formats t as UTC DATE-TIME value.
+func FormatTime(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// Parse parses a single VCALENDAR object from r.
+func Parse(r io.Reader) (Component, error) {
+	lines, err := contentline.Unfold(r)
+	if err != nil {
+		return Component{}, err
+	}
+
+	var stack []*Component
+	var cal *Component
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		p, err := parseProp(line)
+		if err != nil {
+			return Component{}, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		switch p.Name {
+		case "BEGIN":
+			if cal != nil && len(stack) == 0 {
+				return Component{}, fmt.Errorf("%w: line %d: data after end of calendar", ErrSyntax, i+1)
+			}
+			stack = append(stack, &Component{Name: strings.ToUpper(p.Value)})
+			if len(stack) == 1 {
+				if stack[0].Name != "VCALENDAR" {
+					return Component{}, fmt.Errorf("%w: line %d: expected vcalendar, got %q", ErrSyntax, i+1, p.Value)
+				}
+				cal = stack[0]
+			}
+		case "END":
+			if len(stack) == 0 || stack[len(stack)-1].Name != strings.ToUpper(p.Value) {
+				return Component{}, fmt.Errorf("%w: line %d: unexpected end of %q", ErrSyntax, i+1, p.Value)
+			}
+			c := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.Components = append(parent.Components, *c)
+			}
+		default:
+			if len(stack) == 0 {
+				return Component{}, fmt.Errorf("%w: line %d: property outside component", ErrSyntax, i+1)
+			}
+			c := stack[len(stack)-1]
+			c.Props = append(c.Props, p)
+		}
+	}
+	if cal == nil {
+		return Component{}, fmt.Errorf("%w: missing vcalendar", ErrSyntax)
+	} else if len(stack) > 0 {
+		return Component{}, fmt.Errorf("%w: missing end of %s", ErrSyntax, stack[len(stack)-1].Name)
+	}
+	return *cal, nil
+}
+
+func parseProp(line string) (Prop, error) {
+	l, err := contentline.Parse(line, false)
+	if err != nil {
+		return Prop{}, fmt.Errorf("%w: %v", ErrSyntax, err)
+	}
+	return Prop{l.Name, l.Params, l.Value}, nil
+}
+
+// Marshal returns the component in iCalendar format, with CRLF line endings and
+// lines folded at 75 octets.
+func (c Component) Marshal() []byte {
+	var b bytes.Buffer
+	c.write(&b)
+	return b.Bytes()
+}
+
+func (c Component) write(b *bytes.Buffer) {
+	contentline.WriteLine(b, "BEGIN:"+c.Name)
+	for _, p := range c.Props {
+		contentline.Line{Name: p.Name, Params: p.Params, Value: p.Value}.Write(b)
+	}
+	for _, sc := range c.Components {
+		sc.write(b)
+	}
+	contentline.WriteLine(b, "END:"+c.Name)
+}
+
+// Reply returns an iTIP REPLY (RFC 5546) for the events in a REQUEST, from the
+// attendee with email address, with participation status partstat, e.g.
+// ACCEPTED, TENTATIVE or DECLINED. Only the properties required in a reply are
+// included.
+func Reply(req Component, address, partstat string, now time.Time) (Component, error) {
+	reply := Component{
+		Name: "VCALENDAR",
+		Props: []Prop{
+			{Name: "PRODID", Value: "-//mox//EN"},
+			{Name: "VERSION", Value: "2.0"},
+			{Name: "METHOD", Value: "REPLY"},
+		},
+	}
+	for _, ev := range req.Events() {
+		att, ok := FindAttendee(ev, address)
+		if !ok {
+			att = Prop{Name: "ATTENDEE", Value: "mailto:" + address}
+		}
+		att.Params = slicesDeleteParam(att.Params, "RSVP")
+		att.SetParam("PARTSTAT", partstat)
+
+		rev := Component{Name: "VEVENT"}
+		for _, name := range []string{"UID", "RECURRENCE-ID", "SEQUENCE", "ORGANIZER", "DTSTART", "DTEND", "DURATION", "SUMMARY"} {
+			if p, ok := ev.Get(name); ok {
+				rev.Props = append(rev.Props, p)
+			}
+		}
+		rev.Props = append(rev.Props, Prop{Name: "DTSTAMP", Value: FormatTime(now)}, att)
+		reply.Components = append(reply.Components, rev)
+	}
+	if len(reply.Components) == 0 {
+		return Component{}, fmt.Errorf("%w: no events in request", ErrSyntax)
+	}
+	return reply, nil
+}
+
+// FindAttendee returns the ATTENDEE property of the event for email address,
+// compared case-insensitively.
+func FindAttendee(ev Component, address string) (Prop, bool) {
+	for _, p := range ev.All("ATTENDEE") {
+		if strings.EqualFold(p.Address(), address) {
+			return p, true
+		}
+	}
+	return Prop{}, false
+}
+
+func slicesDeleteParam(l []Param, name string) []Param {
+	var r []Param
+	for _, p := range l {
+		if !strings.EqualFold(p.Name, name) {
+			r = append(r, p)
+		}
+	}
+	return r
+}
@@ -0,0 +1,138 @@
+package ical
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func tcompare(t *testing.T, got, exp any) {
+	t.Helper()
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("got:\n%#v\nexpected:\n%#v", got, exp)
+	}
+}
+
+const request = "BEGIN:VCALENDAR\r\n" +
+	"PRODID:-//test//EN\r\n" +
+	"VERSION:2.0\r\n" +
+	"METHOD:REQUEST\r\n" +
+	"BEGIN:VTIMEZONE\r\n" +
+	"TZID:W. Europe Standard Time\r\n" +
+	"BEGIN:STANDARD\r\n" +
+	"DTSTART:16010101T030000\r\n" +
+	"TZOFFSETFROM:+0200\r\n" +
+	"TZOFFSETTO:+0100\r\n" +
+	"END:STANDARD\r\n" +
+	"END:VTIMEZONE\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:event1\r\n" +
+	"SEQUENCE:2\r\n" +
+	"DTSTAMP:20240101T120000Z\r\n" +
+	"DTSTART;TZID=W. Europe Standard Time:20240110T090000\r\n" +
+	"DTEND;TZID=Europe/Amsterdam:20240110T100000\r\n" +
+	"SUMMARY:Planning\\, with a long summary that needs to be folded over multiple li\r\n" +
+	" nes\r\n" +
+	"ORGANIZER;CN=\"Org, Anizer\":mailto:org@mox.example\r\n" +
+	"ATTENDEE;RSVP=TRUE;PARTSTAT=NEEDS-ACTION;CN=Mjl:MAILTO:mjl@mox.example\r\n" +
+	"ATTENDEE;PARTSTAT=ACCEPTED:mailto:other@mox.example\r\n" +
+	"BEGIN:VALARM\r\n" +
+	"TRIGGER:-PT15M\r\n" +
+	"ACTION:DISPLAY\r\n" +
+	"END:VALARM\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestParse(t *testing.T) {
+	cal, err := Parse(strings.NewReader(request))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	tcompare(t, cal.Text("METHOD"), "REQUEST")
+	evs := cal.Events()
+	tcompare(t, len(evs), 1)
+	ev := evs[0]
+	tcompare(t, ev.Text("SUMMARY"), "Planning, with a long summary that needs to be folded over multiple lines")
+	tcompare(t, len(ev.Components), 1)
+
+	org, _ := ev.Get("ORGANIZER")
+	tcompare(t, org.Param("cn"), "Org, Anizer")
+	tcompare(t, org.Address(), "org@mox.example")
+
+	// Start with zone from VTIMEZONE, end with zone from database.
+	p, _ := ev.Get("DTSTART")
+	start, allDay, err := p.Time(cal)
+	if err != nil {
+		t.Fatalf("parse start: %v", err)
+	}
+	tcompare(t, allDay, false)
+	tcompare(t, start.UTC(), time.Date(2024, 1, 10, 8, 0, 0, 0, time.UTC))
+	p, _ = ev.Get("DTEND")
+	end, _, err := p.Time(cal)
+	if err != nil {
+		t.Fatalf("parse end: %v", err)
+	}
+	tcompare(t, end.UTC(), time.Date(2024, 1, 10, 9, 0, 0, 0, time.UTC))
+
+	day, allDay, err := Prop{Name: "DTSTART", Params: []Param{{Name: "VALUE", Values: []string{"DATE"}}}, Value: "20240110"}.Time(cal)
+	tcompare(t, err, nil)
+	tcompare(t, allDay, true)
+	tcompare(t, day, time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC))
+
+	// Round trip.
+	buf := cal.Marshal()
+	for _, line := range strings.Split(string(buf), "\r\n") {
+		if len(line) > 75 {
+			t.Fatalf("line not folded: %q", line)
+		}
+	}
+	ncal, err := Parse(strings.NewReader(string(buf)))
+	if err != nil {
+		t.Fatalf("parse marshaled calendar: %v", err)
+	}
+	tcompare(t, ncal, cal)
+
+	bad := []string{
+		"",
+		"SUMMARY:outside\r\n",
+		"BEGIN:VEVENT\r\nEND:VEVENT\r\n",
+		"BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nEND:VCALENDAR\r\n",
+		"BEGIN:VCALENDAR\r\n",
+		"BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\nBEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n",
+		"BEGIN:VCALENDAR\r\nX;BAD:x\r\nEND:VCALENDAR\r\n",
+	}
+	for _, s := range bad {
+		_, err := Parse(strings.NewReader(s))
+		if !errors.Is(err, ErrSyntax) {
+			t.Fatalf("parsing %q: got err %v, expected ErrSyntax", s, err)
+		}
+	}
+}
+
+func TestReply(t *testing.T) {
+	cal, err := Parse(strings.NewReader(request))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	reply, err := Reply(cal, "MJL@mox.example", "ACCEPTED", now)
+	if err != nil {
+		t.Fatalf("reply: %v", err)
+	}
+	tcompare(t, reply.Text("METHOD"), "REPLY")
+	ev := reply.Events()[0]
+	tcompare(t, ev.Text("UID"), "event1")
+	tcompare(t, ev.Text("SEQUENCE"), "2")
+	tcompare(t, ev.Text("DTSTAMP"), "20240102T030405Z")
+	atts := ev.All("ATTENDEE")
+	tcompare(t, len(atts), 1)
+	tcompare(t, atts[0], Prop{Name: "ATTENDEE", Params: []Param{{Name: "PARTSTAT", Values: []string{"ACCEPTED"}}, {Name: "CN", Values: []string{"Mjl"}}}, Value: "MAILTO:mjl@mox.example"})
+	tcompare(t, len(ev.Components), 0)
+
+	_, err = Reply(Component{Name: "VCALENDAR"}, "mjl@mox.example", "ACCEPTED", now)
+	if !errors.Is(err, ErrSyntax) {
+		t.Fatalf("reply without events, got err %v, expected ErrSyntax", err)
+	}
+}
@@ -1,5 +1,6 @@
 /*
-Package imapclient provides an IMAP4 client, primarily for testing the IMAP4 server.
+Package imapclient provides an IMAP4 client, primarily for testing the IMAP4 server,
+and for migrating messages from other IMAP servers.
 
 Commands can be sent to the server free-form, but responses are parsed strictly.
 Behaviour that may not be required by the IMAP4 specification may be expected by
@@ -61,7 +61,7 @@ func (c *Conn) AuthenticatePlain(username, password string) (untagged []Untagged
 	return
 }
 
-// Authenticate with SCRAM-SHA-256(-PLUS) or SCRAM-SHA-1(-PLUS). With SCRAM, the
+// Authenticate with SCRAM-SHA-512(-PLUS), SCRAM-SHA-256(-PLUS) or SCRAM-SHA-1(-PLUS). With SCRAM, the
 // password is not exchanged in plaintext form, but only derived hashes are
 // exchanged by both parties as proof of knowledge of password.
 //
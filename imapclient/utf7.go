@@ -1,4 +1,4 @@
-package imapserver
+package imapclient
 
 import (
 	"bytes"
@@ -25,7 +25,8 @@ var (
 	errUTF7BadSurrogate     = errors.New("utf7: bad utf16 surrogates")
 )
 
-func utf7decode(s string) (string, error) {
+// UTF7Decode decodes a mailbox name in modified UTF-7.
+func UTF7Decode(s string) (string, error) {
 	var r string
 	var shifted bool
 	var b string
@@ -105,7 +106,8 @@ func utf7decode(s string) (string, error) {
 	return r, nil
 }
 
-func utf7encode(s string) string {
+// UTF7Encode encodes a mailbox name in modified UTF-7.
+func UTF7Encode(s string) string {
 	var r string
 	var code string
 
@@ -1,4 +1,4 @@
-package imapserver
+package imapclient
 
 import (
 	"errors"
@@ -10,7 +10,7 @@ func TestUTF7(t *testing.T) {
 	check := func(input string, output string, expErr error) {
 		t.Helper()
 
-		r, err := utf7decode(input)
+		r, err := UTF7Decode(input)
 		if r != output {
 			t.Fatalf("got %q, expected %q (err %v), for input %q", r, output, err, input)
 		}
@@ -18,7 +18,7 @@ func TestUTF7(t *testing.T) {
 			t.Fatalf("got err %v, expected %v", err, expErr)
 		}
 		if expErr == nil {
-			expInput := utf7encode(output)
+			expInput := UTF7Encode(output)
 			if expInput != input {
 				t.Fatalf("encoding, got %s, expected %s", expInput, input)
 			}
@@ -0,0 +1,766 @@
+// Package imapmigrate copies mailboxes and messages from an account at a remote
+// IMAP server into a local account, for migrating to mox.
+//
+// Remote mailboxes are only read: they are opened with EXAMINE and messages are
+// fetched with BODY.PEEK, so the remote account is left as is. The folder
+// structure, message flags and keywords, and receive times are migrated. The
+// progress is stored in the account database for each remote mailbox, with its
+// UIDVALIDITY and the highest migrated UID, and messages are added in batches.
+// An interrupted migration continues where it left off when started again. Later
+// passes only fetch new messages, and update the flags of migrated messages to
+// those at the remote server, for keeping the account in sync until the switch
+// to mox is complete.
+package imapmigrate
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/imapclient"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/store"
+)
+
+// ErrQuota is returned when the account does not have room for more messages.
+var ErrQuota = errors.New("account over maximum total message size")
+
+// TLSMode is how the connection to the remote IMAP server is protected.
+type TLSMode string
+
+const (
+	TLSImmediate TLSMode = "tls"      // TLS from the start of the connection, typically port 993.
+	TLSStartTLS  TLSMode = "starttls" // STARTTLS after connecting, typically port 143.
+)
+
+// Remote is an account at a remote IMAP server.
+type Remote struct {
+	Host     string // Host name, with optional port. Default port 993 for TLS, 143 for STARTTLS.
+	TLSMode  TLSMode
+	Username string
+	Password string
+
+	// Optional. Default verifies the certificate of the server against the host name.
+	TLSConfig *tls.Config
+
+	// Optional, for connecting other than over TCP, e.g. in tests.
+	Dial func(ctx context.Context, addr string) (net.Conn, error)
+}
+
+func (r Remote) hostAddr() (host, addr string) {
+	host, port, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+		port = "993"
+		if r.TLSMode == TLSStartTLS {
+			port = "143"
+		}
+	}
+	return host, net.JoinHostPort(host, port)
+}
+
+// ID identifies the remote account in the migration progress stored in the
+// account database.
+func (r Remote) ID() string {
+	_, addr := r.hostAddr()
+	return r.Username + "@" + addr
+}
+
+// Options influence how mailboxes are migrated.
+type Options struct {
+	// If set, mailboxes are migrated into child mailboxes of Prefix. Otherwise,
+	// special-use mailboxes such as Sent and Trash at the remote server are migrated
+	// into the local mailboxes with the same special-use.
+	Prefix string
+
+	// If set, called after messages for a mailbox were added, with the total number
+	// of messages added to the mailbox in this pass.
+	Progress func(mailbox string, count int)
+
+	// If set, called for problems that do not stop the migration, such as a mailbox
+	// that cannot be opened.
+	Problem func(msg string)
+}
+
+// Result is the outcome of a migration pass.
+type Result struct {
+	Mailboxes int // Remote mailboxes that were migrated.
+	Messages  int // Messages added.
+	Skipped   int // Messages that were already present locally.
+	Flags     int // Migrated messages with updated flags.
+}
+
+// remoteMsg is a message at the remote server that is to be migrated.
+type remoteMsg struct {
+	UID      uint32
+	Size     int64
+	Received time.Time
+	Flags    []string
+
+	LocalID int64    // If already present locally.
+	File    *os.File // With message, after fetching.
+}
+
+type migration struct {
+	ctx      context.Context
+	log      mlog.Log
+	acc      *store.Account
+	c        *imapclient.Conn
+	remoteID string
+	opts     Options
+	result   Result
+}
+
+func (mg *migration) problemf(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	mg.log.Info("migration problem", slog.String("problem", msg))
+	if mg.opts.Problem != nil {
+		mg.opts.Problem(msg)
+	}
+}
+
+// Migrate copies all mailboxes and their messages from the remote account into
+// acc. Messages that were migrated in an earlier pass are not fetched again, but
+// their flags are updated.
+//
+// Gmail-style virtual mailboxes with special-use \All, \Flagged and \Important
+// are skipped, their messages are also in other mailboxes. Messages removed at
+// the remote server after they were migrated are kept.
+//
+// Messages are added in batches, each in its own transaction. If an error is
+// returned, messages migrated so far are kept, and a next migration continues
+// where this one stopped.
+func Migrate(ctx context.Context, log mlog.Log, acc *store.Account, remote Remote, opts Options) (Result, error) {
+	host, addr := remote.hostAddr()
+
+	if err := acc.ThreadingWait(log); err != nil {
+		return Result{}, fmt.Errorf("waiting for account thread upgrade: %v", err)
+	}
+
+	dial := remote.Dial
+	if dial == nil {
+		dial = func(ctx context.Context, addr string) (net.Conn, error) {
+			d := net.Dialer{Timeout: 30 * time.Second}
+			return d.DialContext(ctx, "tcp", addr)
+		}
+	}
+	conn, err := dial(ctx, addr)
+	if err != nil {
+		return Result{}, fmt.Errorf("connecting to %s: %w", addr, err)
+	}
+	defer conn.Close()
+	// Abort pending reads and writes when the migration is canceled.
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer stop()
+
+	tlsConfig := remote.TLSConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+	}
+	switch remote.TLSMode {
+	case TLSImmediate:
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return Result{}, fmt.Errorf("tls handshake: %w", err)
+		}
+		conn = tlsConn
+	case TLSStartTLS:
+	default:
+		return Result{}, fmt.Errorf("unknown tls mode %q", remote.TLSMode)
+	}
+
+	c, err := imapclient.New(conn, false)
+	if err != nil {
+		return Result{}, fmt.Errorf("imap greeting: %w", err)
+	}
+	if remote.TLSMode == TLSStartTLS {
+		if _, _, err := c.Starttls(tlsConfig); err != nil {
+			return Result{}, fmt.Errorf("starttls: %w", err)
+		}
+	}
+	if _, _, err := c.Login(remote.Username, remote.Password); err != nil {
+		return Result{}, fmt.Errorf("login: %w", err)
+	}
+	defer c.Logout()
+	if _, _, err := c.Capability(); err != nil {
+		return Result{}, fmt.Errorf("capability: %w", err)
+	}
+	// Without UTF8=ACCEPT, mailbox names are in modified UTF-7.
+	_, utf8 := c.CapAvailable[imapclient.CapUTF8Accept]
+	if utf8 {
+		if _, _, err := c.Enable(string(imapclient.CapUTF8Accept)); err != nil {
+			return Result{}, fmt.Errorf("enabling utf8: %w", err)
+		}
+	}
+
+	mg := &migration{ctx: ctx, log: log, acc: acc, c: c, remoteID: remote.ID(), opts: opts}
+
+	untagged, _, err := c.List("*")
+	if err != nil {
+		return Result{}, fmt.Errorf("listing mailboxes: %w", err)
+	}
+	var lists []imapclient.UntaggedList
+	for _, u := range untagged {
+		if l, ok := u.(imapclient.UntaggedList); ok {
+			lists = append(lists, l)
+		}
+	}
+	sort.Slice(lists, func(i, j int) bool {
+		return lists[i].Mailbox < lists[j].Mailbox
+	})
+
+	// Local mailboxes by special-use, for migrating e.g. "[Gmail]/Sent Mail" into "Sent".
+	specialUse := map[string]string{}
+	if opts.Prefix == "" {
+		mailboxes, err := bstore.QueryDB[store.Mailbox](ctx, acc.DB).List()
+		if err != nil {
+			return Result{}, fmt.Errorf("listing local mailboxes: %v", err)
+		}
+		for _, mb := range mailboxes {
+			for flag, ok := range map[string]bool{`\archive`: mb.Archive, `\drafts`: mb.Draft, `\junk`: mb.Junk, `\sent`: mb.Sent, `\trash`: mb.Trash} {
+				if ok {
+					specialUse[flag] = mb.Name
+				}
+			}
+		}
+	}
+
+	for _, l := range lists {
+		if hasFlag(l.Flags, `\noselect`, `\nonexistent`, `\all`, `\flagged`, `\important`) {
+			continue
+		}
+		name, err := localName(l, utf8, opts.Prefix, specialUse)
+		if err != nil {
+			mg.problemf("mailbox %q: %v (skipping)", l.Mailbox, err)
+			continue
+		}
+		if err := mg.mailbox(l.Mailbox, name); err != nil {
+			return mg.result, fmt.Errorf("mailbox %q: %w", name, err)
+		}
+		mg.result.Mailboxes++
+	}
+	return mg.result, nil
+}
+
+func hasFlag(flags []string, l ...string) bool {
+	for _, f := range flags {
+		if slices.Contains(l, strings.ToLower(f)) {
+			return true
+		}
+	}
+	return false
+}
+
+// localName returns the name of the local mailbox for a remote mailbox.
+func localName(l imapclient.UntaggedList, utf8 bool, prefix string, specialUse map[string]string) (string, error) {
+	if prefix == "" {
+		for _, f := range l.Flags {
+			if name, ok := specialUse[strings.ToLower(f)]; ok {
+				return name, nil
+			}
+		}
+	}
+
+	name := l.Mailbox
+	if !utf8 {
+		var err error
+		name, err = imapclient.UTF7Decode(name)
+		if err != nil {
+			return "", fmt.Errorf("decoding mailbox name: %v", err)
+		}
+	}
+	name = norm.NFC.String(name)
+	// Convert the hierarchy separator. Slashes in names at servers with another
+	// separator cannot be represented.
+	if l.Separator != 0 && l.Separator != '/' {
+		elems := strings.Split(name, string(l.Separator))
+		for i, e := range elems {
+			elems[i] = strings.ReplaceAll(e, "/", "-")
+		}
+		name = strings.Join(elems, "/")
+	}
+	if prefix != "" {
+		name = prefix + "/" + name
+	}
+	name, _, err := store.CheckMailboxName(name, true)
+	return name, err
+}
+
+// remoteFlags returns the flags and keywords to store for flags at the remote
+// server. Flags that cannot be stored, such as \Recent, are ignored.
+func remoteFlags(l []string) (store.Flags, []string) {
+	var xl []string
+	for _, f := range l {
+		if _, _, err := store.ParseFlagsKeywords([]string{f}); err == nil {
+			xl = append(xl, f)
+		}
+	}
+	flags, keywords, _ := store.ParseFlagsKeywords(xl)
+	return flags, keywords
+}
+
+// mailbox migrates the remote mailbox into the local mailbox name.
+func (mg *migration) mailbox(remoteName, name string) error {
+	untagged, _, err := mg.c.Examine(remoteName)
+	if err != nil {
+		if errors.Is(err, net.ErrClosed) || mg.ctx.Err() != nil {
+			return err
+		}
+		mg.problemf("opening mailbox %q: %v (skipping)", remoteName, err)
+		return nil
+	}
+	var uidValidity, exists uint32
+	for _, u := range untagged {
+		switch x := u.(type) {
+		case imapclient.UntaggedResult:
+			if c, ok := x.CodeArg.(imapclient.CodeUint); ok && c.Code == "UIDVALIDITY" {
+				uidValidity = c.Num
+			}
+		case imapclient.UntaggedExists:
+			exists = uint32(x)
+		}
+	}
+	if uidValidity == 0 {
+		mg.problemf("no uidvalidity for mailbox %q (skipping)", remoteName)
+		return nil
+	}
+
+	// Create the local mailbox, also when empty, to mirror the folder structure.
+	if err := mg.write(func(tx *bstore.Tx, changes *[]store.Change) error {
+		_, nchanges, err := mg.acc.MailboxEnsure(tx, name, true)
+		*changes = append(*changes, nchanges...)
+		return err
+	}); err != nil {
+		return fmt.Errorf("ensuring mailbox: %w", err)
+	}
+
+	mm, err := bstore.QueryDB[store.MigrationMailbox](mg.ctx, mg.acc.DB).FilterNonzero(store.MigrationMailbox{Remote: mg.remoteID, RemoteName: remoteName}).Get()
+	if err == bstore.ErrAbsent {
+		mm = store.MigrationMailbox{Remote: mg.remoteID, RemoteName: remoteName}
+	} else if err != nil {
+		return fmt.Errorf("get migration progress: %v", err)
+	}
+	if mm.ID != 0 && mm.UIDValidity != uidValidity {
+		mg.problemf("uidvalidity of mailbox %q changed, migrating it again, skipping messages already present", remoteName)
+		err := mg.acc.DB.Write(mg.ctx, func(tx *bstore.Tx) error {
+			_, err := bstore.QueryTx[store.MigrationMessage](tx).FilterNonzero(store.MigrationMessage{MigrationMailboxID: mm.ID}).Delete()
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("removing migrated messages for old uidvalidity: %v", err)
+		}
+		mm.LastUID = 0
+	}
+	mm.UIDValidity = uidValidity
+	if exists == 0 {
+		return nil
+	}
+
+	if mm.LastUID > 0 {
+		if err := mg.syncFlags(mm); err != nil {
+			return fmt.Errorf("updating flags: %w", err)
+		}
+	}
+
+	untagged, _, err = mg.c.Transactf("uid fetch %d:* (uid rfc822.size internaldate flags)", mm.LastUID+1)
+	if err != nil {
+		return fmt.Errorf("listing messages: %w", err)
+	}
+	var msgs []*remoteMsg
+	for _, f := range fetches(untagged) {
+		// With "n:*", the last message is returned even if its UID is lower than n.
+		if f.UID > mm.LastUID {
+			msgs = append(msgs, f)
+		}
+	}
+	sort.Slice(msgs, func(i, j int) bool {
+		return msgs[i].UID < msgs[j].UID
+	})
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	// When migrating a mailbox for the first time, messages that are already in the
+	// local mailbox, e.g. through an earlier import, are linked instead of added.
+	if mm.LastUID == 0 {
+		present := map[string]int64{}
+		err := mg.acc.DB.Read(mg.ctx, func(tx *bstore.Tx) error {
+			mb, err := bstore.QueryTx[store.Mailbox](tx).FilterNonzero(store.Mailbox{Name: name}).Get()
+			if err != nil {
+				return err
+			}
+			return bstore.QueryTx[store.Message](tx).FilterNonzero(store.Message{MailboxID: mb.ID}).FilterEqual("Expunged", false).ForEach(func(m store.Message) error {
+				present[fmt.Sprintf("%d %d", m.Received.Unix(), m.Size)] = m.ID
+				return nil
+			})
+		})
+		if err != nil {
+			return fmt.Errorf("listing local messages: %v", err)
+		}
+		for _, m := range msgs {
+			m.LocalID = present[fmt.Sprintf("%d %d", m.Received.Unix(), m.Size)]
+		}
+	}
+
+	// Fetch and add in batches, limited by count and size.
+	const batchCount = 100
+	const batchSize = 16 * 1024 * 1024
+	var count int
+	for len(msgs) > 0 {
+		var n int
+		var size int64
+		for n < len(msgs) && n < batchCount && (n == 0 || size+msgs[n].Size <= batchSize) {
+			if msgs[n].LocalID == 0 {
+				size += msgs[n].Size
+			}
+			n++
+		}
+		batch := msgs[:n]
+		msgs = msgs[n:]
+
+		added, err := mg.batch(&mm, name, batch)
+		if err != nil {
+			return err
+		}
+		count += added
+		if mg.opts.Progress != nil {
+			mg.opts.Progress(name, count)
+		}
+	}
+	return nil
+}
+
+// write runs fn in a write transaction with the account write lock held, and
+// broadcasts the changes after committing.
+func (mg *migration) write(fn func(tx *bstore.Tx, changes *[]store.Change) error) (rerr error) {
+	mg.acc.WithWLock(func() {
+		var changes []store.Change
+		rerr = mg.acc.DB.Write(mg.ctx, func(tx *bstore.Tx) error {
+			return fn(tx, &changes)
+		})
+		if rerr == nil {
+			store.BroadcastChanges(mg.acc, changes)
+		}
+	})
+	return
+}
+
+// fetches returns the messages from untagged FETCH responses.
+func fetches(untagged []imapclient.Untagged) []*remoteMsg {
+	var l []*remoteMsg
+	for _, u := range untagged {
+		f, ok := u.(imapclient.UntaggedFetch)
+		if !ok {
+			continue
+		}
+		m := &remoteMsg{}
+		for _, a := range f.Attrs {
+			switch x := a.(type) {
+			case imapclient.FetchUID:
+				m.UID = uint32(x)
+			case imapclient.FetchRFC822Size:
+				m.Size = int64(x)
+			case imapclient.FetchInternalDate:
+				m.Received, _ = time.Parse("_2-Jan-2006 15:04:05 -0700", string(x))
+			case imapclient.FetchFlags:
+				m.Flags = x
+			}
+		}
+		if m.UID != 0 {
+			l = append(l, m)
+		}
+	}
+	return l
+}
+
+// batch fetches the messages that are not yet present, and adds them to the
+// local mailbox, updating the migration progress in the same transaction.
+func (mg *migration) batch(mm *store.MigrationMailbox, name string, batch []*remoteMsg) (added int, rerr error) {
+	defer func() {
+		for _, m := range batch {
+			if m.File != nil {
+				store.CloseRemoveTempFile(mg.log, m.File, "migrated message")
+				m.File = nil
+			}
+		}
+	}()
+
+	var uids []string
+	byUID := map[uint32]*remoteMsg{}
+	for _, m := range batch {
+		if m.LocalID == 0 {
+			uids = append(uids, fmt.Sprintf("%d", m.UID))
+			byUID[m.UID] = m
+		}
+	}
+	if len(uids) > 0 {
+		untagged, _, err := mg.c.Transactf("uid fetch %s (uid body.peek[])", strings.Join(uids, ","))
+		if err != nil {
+			return 0, fmt.Errorf("fetching messages: %w", err)
+		}
+		for _, u := range untagged {
+			f, ok := u.(imapclient.UntaggedFetch)
+			if !ok {
+				continue
+			}
+			var uid uint32
+			var body *string
+			for _, a := range f.Attrs {
+				switch x := a.(type) {
+				case imapclient.FetchUID:
+					uid = uint32(x)
+				case imapclient.FetchBody:
+					if x.Section == "" {
+						body = &x.Body
+					}
+				}
+			}
+			m := byUID[uid]
+			if m == nil || body == nil || m.File != nil {
+				continue
+			}
+			mf, err := store.CreateMessageTemp(mg.log, "migrate")
+			if err != nil {
+				return 0, fmt.Errorf("creating temporary file for message: %v", err)
+			}
+			m.File = mf
+			if _, err := io.WriteString(mf, *body); err != nil {
+				return 0, fmt.Errorf("writing temporary file for message: %v", err)
+			}
+			m.Size = int64(len(*body))
+		}
+	}
+
+	var deliveredIDs []int64
+	xmm := *mm
+	err := mg.write(func(tx *bstore.Tx, changes *[]store.Change) error {
+		mb, nchanges, err := mg.acc.MailboxEnsure(tx, name, true)
+		if err != nil {
+			return fmt.Errorf("ensuring mailbox: %v", err)
+		}
+		*changes = append(*changes, nchanges...)
+
+		if xmm.ID == 0 {
+			if err := tx.Insert(&xmm); err != nil {
+				return fmt.Errorf("inserting migration progress: %v", err)
+			}
+		}
+
+		var size int64
+		for _, m := range batch {
+			if m.File != nil {
+				size += m.Size
+			}
+		}
+		if ok, maxSize, err := mg.acc.CanAddMessageSize(tx, size); err != nil {
+			return err
+		} else if !ok {
+			return fmt.Errorf("%w (%d)", ErrQuota, maxSize)
+		}
+
+		modseq, err := mg.acc.NextModSeq(tx)
+		if err != nil {
+			return fmt.Errorf("assigning next modseq: %v", err)
+		}
+
+		var msgs []store.Message
+		var keywords []string
+		for _, rm := range batch {
+			xmm.LastUID = max(xmm.LastUID, rm.UID)
+			id := rm.LocalID
+			if id != 0 {
+				mg.result.Skipped++
+			} else if rm.File == nil {
+				mg.problemf("message with uid %d in mailbox %q not returned by server (skipping)", rm.UID, mm.RemoteName)
+				continue
+			} else {
+				flags, kw := remoteFlags(rm.Flags)
+				m := store.Message{
+					MailboxID:     mb.ID,
+					MailboxOrigID: mb.ID,
+					Received:      rm.Received,
+					Flags:         flags,
+					Keywords:      kw,
+					Size:          rm.Size,
+					CreateSeq:     modseq,
+					ModSeq:        modseq,
+				}
+				if m.Received.IsZero() {
+					m.Received = time.Now()
+				}
+				const sync = true
+				const notrain = true
+				const nothreads = false
+				const updateDiskUsage = true
+				if err := mg.acc.DeliverMessage(mg.log, tx, &m, rm.File, sync, notrain, nothreads, updateDiskUsage); err != nil {
+					return fmt.Errorf("delivering message: %v", err)
+				}
+				deliveredIDs = append(deliveredIDs, m.ID)
+				mb.Add(m.MailboxCounts())
+				keywords = append(keywords, m.Keywords...)
+				msgs = append(msgs, m)
+				*changes = append(*changes, m.ChangeAddUID())
+				id = m.ID
+			}
+			if err := tx.Insert(&store.MigrationMessage{MigrationMailboxID: xmm.ID, RemoteUID: rm.UID, MessageID: id}); err != nil {
+				return fmt.Errorf("inserting migrated message: %v", err)
+			}
+		}
+
+		// Train the junk filter once for the batch.
+		if err := mg.acc.RetrainMessages(mg.ctx, mg.log, tx, msgs, false); err != nil {
+			return fmt.Errorf("training junk filter: %v", err)
+		}
+		for i := range msgs {
+			if msgs[i].TrainedJunk != nil {
+				if err := tx.Update(&msgs[i]); err != nil {
+					return fmt.Errorf("updating message after training: %v", err)
+				}
+			}
+		}
+
+		// Get mailbox again, uidnext is likely updated.
+		mc := mb.MailboxCounts
+		if err := tx.Get(&mb); err != nil {
+			return fmt.Errorf("get mailbox: %v", err)
+		}
+		mb.MailboxCounts = mc
+		var mbKwChanged bool
+		mb.Keywords, mbKwChanged = store.MergeKeywords(mb.Keywords, keywords)
+		if mbKwChanged {
+			*changes = append(*changes, mb.ChangeKeywords())
+		}
+		if err := tx.Update(&mb); err != nil {
+			return fmt.Errorf("updating mailbox: %v", err)
+		}
+		*changes = append(*changes, mb.ChangeCounts())
+
+		xmm.Updated = time.Now()
+		if err := tx.Update(&xmm); err != nil {
+			return fmt.Errorf("updating migration progress: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		for _, id := range deliveredIDs {
+			p := mg.acc.MessagePath(id)
+			xerr := os.Remove(p)
+			mg.log.Check(xerr, "removing message file after migration error", slog.String("path", p))
+		}
+		return 0, err
+	}
+	*mm = xmm
+	mg.result.Messages += len(deliveredIDs)
+	return len(deliveredIDs), nil
+}
+
+// syncFlags updates the flags of messages migrated earlier to the flags at the
+// remote server. Junk flags that were set locally, e.g. for messages in the Junk
+// mailbox, are kept.
+func (mg *migration) syncFlags(mm store.MigrationMailbox) error {
+	untagged, _, err := mg.c.Transactf("uid fetch 1:%d (uid flags)", mm.LastUID)
+	if err != nil {
+		return fmt.Errorf("fetching flags: %w", err)
+	}
+	remote := map[uint32][]string{}
+	for _, f := range fetches(untagged) {
+		remote[f.UID] = f.Flags
+	}
+
+	return mg.write(func(tx *bstore.Tx, changes *[]store.Change) error {
+		links, err := bstore.QueryTx[store.MigrationMessage](tx).FilterNonzero(store.MigrationMessage{MigrationMailboxID: mm.ID}).List()
+		if err != nil {
+			return fmt.Errorf("listing migrated messages: %v", err)
+		}
+
+		var modseq store.ModSeq
+		mailboxes := map[int64]*store.Mailbox{}
+		var msgs []store.Message
+		var origFlags []store.Flags
+		for _, link := range links {
+			rflags, ok := remote[link.RemoteUID]
+			if !ok {
+				continue
+			}
+			m := store.Message{ID: link.MessageID}
+			if err := tx.Get(&m); err == bstore.ErrAbsent || err == nil && m.Expunged {
+				continue
+			} else if err != nil {
+				return fmt.Errorf("get message: %v", err)
+			}
+
+			flags, keywords := remoteFlags(rflags)
+			nflags := m.Flags
+			nflags.Seen = flags.Seen
+			nflags.Answered = flags.Answered
+			nflags.Flagged = flags.Flagged
+			nflags.Deleted = flags.Deleted
+			nflags.Draft = flags.Draft
+			nflags.Forwarded = flags.Forwarded
+			nflags.MDNSent = flags.MDNSent
+			nflags.Junk = nflags.Junk || flags.Junk
+			nflags.Notjunk = nflags.Notjunk || flags.Notjunk
+			nflags.Phishing = nflags.Phishing || flags.Phishing
+			if nflags == m.Flags && slices.Equal(keywords, m.Keywords) {
+				continue
+			}
+
+			mb := mailboxes[m.MailboxID]
+			if mb == nil {
+				mb = &store.Mailbox{ID: m.MailboxID}
+				if err := tx.Get(mb); err != nil {
+					return fmt.Errorf("get mailbox: %v", err)
+				}
+				mailboxes[mb.ID] = mb
+			}
+			if modseq == 0 {
+				modseq, err = mg.acc.NextModSeq(tx)
+				if err != nil {
+					return fmt.Errorf("assigning next modseq: %v", err)
+				}
+			}
+
+			mb.Sub(m.MailboxCounts())
+			origFlags = append(origFlags, m.Flags)
+			m.Flags = nflags
+			m.Keywords = keywords
+			m.ModSeq = modseq
+			mb.Add(m.MailboxCounts())
+			mb.Keywords, _ = store.MergeKeywords(mb.Keywords, keywords)
+			msgs = append(msgs, m)
+		}
+
+		if err := mg.acc.RetrainMessages(mg.ctx, mg.log, tx, msgs, false); err != nil {
+			return fmt.Errorf("training junk filter: %v", err)
+		}
+		for i := range msgs {
+			if err := tx.Update(&msgs[i]); err != nil {
+				return fmt.Errorf("updating message: %v", err)
+			}
+			*changes = append(*changes, msgs[i].ChangeFlags(origFlags[i]))
+		}
+		for _, mb := range mailboxes {
+			if err := tx.Update(mb); err != nil {
+				return fmt.Errorf("updating mailbox: %v", err)
+			}
+			*changes = append(*changes, mb.ChangeCounts(), mb.ChangeKeywords())
+		}
+		mg.result.Flags += len(msgs)
+		return nil
+	})
+}
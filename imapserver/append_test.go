@@ -90,3 +90,47 @@ func TestAppend(t *testing.T) {
 	tclimit.transactf("no", "append inbox (\\Seen Label1 $label2) \" 1-Jan-2022 10:10:00 +0100\" {1+}\r\nx")
 	tclimit.xcode("OVERQUOTA")
 }
+
+func TestAppendLimit(t *testing.T) {
+	defer mockUIDValidity()()
+
+	tc := startArgsMore(t, true, false, true, false, true, "mjl", 10)
+	defer tc.close()
+
+	tc.client.Login("mjl@mox.example", password0)
+	tc.client.Select("inbox")
+
+	// With a maximum message size, LITERAL- is announced instead of LITERAL+.
+	tc.transactf("ok", "capability")
+	if _, ok := tc.client.CapAvailable[imapclient.CapLiteralMinus]; !ok {
+		t.Fatalf("LITERAL- not announced")
+	}
+	if _, ok := tc.client.CapAvailable[imapclient.CapLiteralPlus]; ok {
+		t.Fatalf("LITERAL+ announced")
+	}
+	if _, ok := tc.client.CapAvailable["APPENDLIMIT=10"]; !ok {
+		t.Fatalf("APPENDLIMIT=10 not announced")
+	}
+
+	tc.transactf("ok", "status inbox (APPENDLIMIT)")
+	tc.xuntagged(imapclient.UntaggedStatus{Mailbox: "Inbox", Attrs: map[imapclient.StatusAttr]int64{imapclient.StatusAppendLimit: 10}})
+
+	// Synchronizing literal that is too large is rejected before the data is sent.
+	tc.transactf("no", "append inbox {11}")
+	tc.xcode("TOOBIG")
+
+	// Small synchronizing literal is fine.
+	tc.cmdf("", "append inbox {1}")
+	tc.readprefixline("+ ")
+	tc.writelinef("x")
+	tc.readstatus("ok")
+
+	// Small non-synchronizing literal is rejected after reading, without aborting the connection.
+	tc.transactf("no", "append inbox {11+}\r\n01234567890")
+	tc.xcode("TOOBIG")
+	tc.transactf("ok", "noop")
+
+	// Non-synchronizing literal larger than 4096 bytes aborts the connection.
+	tc.transactf("bad", "append inbox {4097+}")
+	tc.xcode("TOOBIG")
+}
@@ -1,19 +1,32 @@
 package imapserver
 
 import (
+	"crypto/ed25519"
 	"crypto/hmac"
 	"crypto/md5"
+	cryptorand "crypto/rand"
 	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/base64"
 	"errors"
 	"fmt"
 	"hash"
+	"math/big"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	"golang.org/x/text/secure/precis"
 
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/imapclient"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/oidc/oidctest"
 	"github.com/mjl-/mox/scram"
 )
 
@@ -73,6 +86,22 @@ func TestAuthenticatePlain(t *testing.T) {
 	tc.readstatus("ok")
 }
 
+func TestAuthenticateNoPlaintextAuth(t *testing.T) {
+	tc := startArgsMore(t, true, false, true, true, true, "mjl", 0)
+	defer tc.close()
+
+	if _, ok := tc.client.CapAvailable["LOGINDISABLED"]; !ok {
+		t.Fatalf("missing capability LOGINDISABLED")
+	}
+	if _, ok := tc.client.CapAvailable["AUTH=PLAIN"]; ok {
+		t.Fatalf("unexpected capability AUTH=PLAIN")
+	}
+	tc.transactf("no", `login mjl@mox.example "%s"`, password0)
+	tc.transactf("no", "authenticate plain %s", base64.StdEncoding.EncodeToString([]byte("\u0000mjl@mox.example\u0000"+password0)))
+	_, _, err := tc.client.AuthenticateSCRAM("SCRAM-SHA-512", sha512.New, "mjl@mox.example", password0)
+	tcheck(t, err, "authenticate with scram")
+}
+
 func TestAuthenticateSCRAMSHA1(t *testing.T) {
 	testAuthenticateSCRAM(t, false, "SCRAM-SHA-1", sha1.New)
 }
@@ -81,6 +110,10 @@ func TestAuthenticateSCRAMSHA256(t *testing.T) {
 	testAuthenticateSCRAM(t, false, "SCRAM-SHA-256", sha256.New)
 }
 
+func TestAuthenticateSCRAMSHA512(t *testing.T) {
+	testAuthenticateSCRAM(t, false, "SCRAM-SHA-512", sha512.New)
+}
+
 func TestAuthenticateSCRAMSHA1PLUS(t *testing.T) {
 	testAuthenticateSCRAM(t, true, "SCRAM-SHA-1-PLUS", sha1.New)
 }
@@ -89,6 +122,10 @@ func TestAuthenticateSCRAMSHA256PLUS(t *testing.T) {
 	testAuthenticateSCRAM(t, true, "SCRAM-SHA-256-PLUS", sha256.New)
 }
 
+func TestAuthenticateSCRAMSHA512PLUS(t *testing.T) {
+	testAuthenticateSCRAM(t, true, "SCRAM-SHA-512-PLUS", sha512.New)
+}
+
 func testAuthenticateSCRAM(t *testing.T, tls bool, method string, h func() hash.Hash) {
 	tc := startArgs(t, true, tls, true, true, "mjl")
 	tc.client.AuthenticateSCRAM(method, h, "mjl@mox.example", password0)
@@ -210,3 +247,171 @@ func TestAuthenticateCRAMMD5(t *testing.T) {
 	auth("ok", "mo\u0301x@mox.example", password1)
 	tc.close()
 }
+
+func TestAuthenticateOAuth(t *testing.T) {
+	idp := oidctest.NewIDP()
+	defer idp.Close()
+
+	tc := start(t)
+
+	hasCap := func(c string) bool {
+		tc.transactf("ok", "capability")
+		for _, u := range tc.lastUntagged {
+			if caps, ok := u.(imapclient.UntaggedCapability); ok && slices.Contains(caps, c) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Not announced and not supported without OIDC configuration.
+	if hasCap("AUTH=OAUTHBEARER") {
+		t.Fatalf("oauthbearer announced without oidc configuration")
+	}
+	tc.transactf("no", "authenticate oauthbearer %s", base64.StdEncoding.EncodeToString([]byte("n,,\x01auth=Bearer x\x01\x01")))
+
+	// Configuration is reloaded for each test connection.
+	oidcConf := &config.OIDC{Issuer: idp.Server.URL, ClientID: "mox", Audiences: []string{"imap"}}
+	mox.Conf.Static.OIDC = oidcConf
+
+	if !hasCap("AUTH=OAUTHBEARER") || !hasCap("AUTH=XOAUTH2") {
+		t.Fatalf("oauth mechanisms not announced with oidc configuration")
+	}
+
+	bearer := func(user, token string) string {
+		return base64.StdEncoding.EncodeToString([]byte("n,a=" + user + ",\x01host=mox.example\x01auth=Bearer " + token + "\x01\x01"))
+	}
+	xoauth2 := func(user, token string) string {
+		return base64.StdEncoding.EncodeToString([]byte("user=" + user + "\x01auth=Bearer " + token + "\x01\x01"))
+	}
+
+	tc.transactf("bad", "authenticate oauthbearer %s", base64.StdEncoding.EncodeToString([]byte("n,,auth=Bearer x")))
+
+	// Bad tokens get error details as challenge, which the client must respond to.
+	for _, x := range []struct{ mech, resp string }{
+		{"oauthbearer", bearer("mjl@mox.example", "bogus")},
+		{"oauthbearer", bearer("mjl@mox.example", idp.AccessToken("other", "mjl@mox.example"))},  // Wrong audience.
+		{"oauthbearer", bearer("other@mox.example", idp.AccessToken("imap", "mjl@mox.example"))}, // Other user.
+		{"oauthbearer", bearer("", idp.AccessToken("imap", "unknown@mox.example"))},              // Unknown address.
+		{"xoauth2", xoauth2("mjl@mox.example", "bogus")},
+	} {
+		tc.cmdf("", "authenticate %s %s", x.mech, x.resp)
+		line, err := tc.client.Readline()
+		tcheck(t, err, "read line")
+		buf, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(line, "+ "))
+		tcheck(t, err, "decode challenge")
+		if !strings.Contains(string(buf), "invalid_token") {
+			t.Fatalf("unexpected challenge %q", buf)
+		}
+		tc.writelinef("%s", base64.StdEncoding.EncodeToString([]byte("\x01")))
+		tc.readstatus("no")
+		tc.xcode("AUTHENTICATIONFAILED")
+	}
+
+	tc.transactf("ok", "authenticate oauthbearer %s", bearer("mjl@mox.example", idp.AccessToken("imap", "mjl@mox.example")))
+	tc.close()
+
+	tc = start(t)
+	mox.Conf.Static.OIDC = oidcConf
+	tc.transactf("ok", "authenticate xoauth2 %s", xoauth2("mjl@mox.example", idp.AccessToken("imap", "mjl@mox.example")))
+	tc.close()
+
+	// Without authzid.
+	tc = start(t)
+	defer tc.close()
+	mox.Conf.Static.OIDC = oidcConf
+	tc.transactf("ok", "authenticate oauthbearer %s", bearer("", idp.AccessToken("imap", "mjl@mox.example")))
+}
+
+func TestAuthenticateExternal(t *testing.T) {
+	caKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caBuf, err := x509.CreateCertificate(cryptorand.Reader, caTmpl, caTmpl, caKey.Public(), caKey)
+	tcheck(t, err, "create ca certificate")
+	ca, err := x509.ParseCertificate(caBuf)
+	tcheck(t, err, "parse ca certificate")
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	clientCert := func(email string) tls.Certificate {
+		_, key, err := ed25519.GenerateKey(cryptorand.Reader)
+		tcheck(t, err, "generate key")
+		tmpl := &x509.Certificate{
+			SerialNumber:   big.NewInt(2),
+			Subject:        pkix.Name{CommonName: "client"},
+			EmailAddresses: []string{email},
+			NotBefore:      time.Now().Add(-time.Hour),
+			NotAfter:       time.Now().Add(time.Hour),
+			ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}
+		buf, err := x509.CreateCertificate(cryptorand.Reader, tmpl, ca, key.Public(), caKey)
+		tcheck(t, err, "create client certificate")
+		return tls.Certificate{Certificate: [][]byte{buf}, PrivateKey: key}
+	}
+
+	startCert := func(certs ...tls.Certificate) *testconn {
+		serverConfig := &tls.Config{
+			Certificates: []tls.Certificate{fakeCert(t)},
+			ClientAuth:   tls.VerifyClientCertIfGiven,
+			ClientCAs:    pool,
+		}
+		clientConfig := &tls.Config{InsecureSkipVerify: true, Certificates: certs}
+		return startArgsTLS(t, true, true, false, false, true, "mjl", 0, serverConfig, clientConfig)
+	}
+
+	hasExternal := func(tc *testconn) bool {
+		tc.transactf("ok", "capability")
+		for _, u := range tc.lastUntagged {
+			if caps, ok := u.(imapclient.UntaggedCapability); ok && slices.Contains(caps, "AUTH=EXTERNAL") {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Not announced and not supported without client authentication on the listener.
+	tc := startArgs(t, true, true, false, true, "mjl")
+	if hasExternal(tc) {
+		t.Fatalf("external announced without client authentication")
+	}
+	tc.transactf("no", "authenticate external =")
+	tc.close()
+
+	// Without authorization identity, the address from the certificate is used.
+	tc = startCert(clientCert("mjl@mox.example"))
+	if !hasExternal(tc) {
+		t.Fatalf("external not announced with client authentication")
+	}
+	tc.transactf("ok", "authenticate external =")
+	tc.close()
+
+	tc = startCert(clientCert("mjl@mox.example"))
+	tc.transactf("ok", "authenticate external %s", base64.StdEncoding.EncodeToString([]byte("mjl@mox.example")))
+	tc.close()
+
+	// Identity not in certificate.
+	tc = startCert(clientCert("mjl@mox.example"))
+	tc.transactf("no", "authenticate external %s", base64.StdEncoding.EncodeToString([]byte("móx@mox.example")))
+	tc.xcode("AUTHENTICATIONFAILED")
+	tc.close()
+
+	// Address in certificate is not of an account.
+	tc = startCert(clientCert("unknown@mox.example"))
+	tc.transactf("no", "authenticate external =")
+	tc.xcode("AUTHENTICATIONFAILED")
+	tc.close()
+
+	// No client certificate.
+	tc = startCert()
+	defer tc.close()
+	tc.transactf("no", "authenticate external =")
+	tc.xcode("AUTHENTICATIONFAILED")
+}
@@ -283,26 +283,27 @@ func (cmd *fetchCmd) xensureMessage() *store.Message {
 	return cmd.m
 }
 
+// xensureReader returns a reader for the message, without loading its parsed
+// structure, e.g. for fetching the full message.
+func (cmd *fetchCmd) xensureReader() *store.MsgReader {
+	if cmd.msgr == nil {
+		m := cmd.xensureMessage()
+		cmd.msgr = cmd.conn.account.MessageReader(*m)
+	}
+	return cmd.msgr
+}
+
 func (cmd *fetchCmd) xensureParsed() (*store.MsgReader, *message.Part) {
-	if cmd.msgr != nil {
+	if cmd.part != nil {
 		return cmd.msgr, cmd.part
 	}
 
-	m := cmd.xensureMessage()
-
-	cmd.msgr = cmd.conn.account.MessageReader(*m)
-	defer func() {
-		if cmd.part == nil {
-			err := cmd.msgr.Close()
-			cmd.conn.xsanity(err, "closing messagereader")
-			cmd.msgr = nil
-		}
-	}()
-
-	p, err := m.LoadPart(cmd.msgr)
+	// The message reader is closed at the end of processing, also on errors.
+	msgr := cmd.xensureReader()
+	p, err := cmd.xensureMessage().LoadPart(msgr)
 	xcheckf(err, "load parsed message")
 	cmd.part = &p
-	return cmd.msgr, cmd.part
+	return msgr, cmd.part
 }
 
 func (cmd *fetchCmd) process(atts []fetchAtt) {
@@ -579,7 +580,7 @@ func (cmd *fetchCmd) xbinaryMessageReader(p *message.Part) io.Reader {
 }
 
 // return header with only fields, or with everything except fields if "not" is set.
-func (cmd *fetchCmd) xmodifiedHeader(p *message.Part, fields []string, not bool) io.Reader {
+func (cmd *fetchCmd) xmodifiedHeader(p *message.Part, fields []string, not bool) *bytes.Buffer {
 	h, err := io.ReadAll(p.HeaderReader())
 	cmd.xcheckf(err, "reading header")
 
@@ -639,11 +640,23 @@ func (cmd *fetchCmd) xbinary(a fetchAtt) (string, token) {
 		xusercodeErrorf("UNKNOWN-CTE", "unknown Content-Transfer-Encoding %q", p.ContentTransferEncoding)
 	}
 
-	r := p.Reader()
-	if a.partial != nil {
-		r = cmd.xpartialReader(a.partial, r)
+	// The decoded size is known, so large attachments are written without reading
+	// them into memory first.
+	return cmd.sectionRespField(a), cmd.xsizedLiteral(p.Reader(), p.DecodedSize, a.partial)
+}
+
+// xsizedLiteral returns a literal for data of size bytes from r, limited to partial
+// if set. The data is written to the connection while reading from r, without
+// reading it into memory first.
+func (cmd *fetchCmd) xsizedLiteral(r io.Reader, size int64, partial *partial) token {
+	if partial == nil {
+		return readerSizeSyncliteral{r, size}
 	}
-	return cmd.sectionRespField(a), readerSyncliteral{r}
+	offset := min(int64(partial.offset), size)
+	count := min(int64(partial.count), size-offset)
+	_, err := io.CopyN(io.Discard, r, offset)
+	cmd.xcheckf(err, "skipping to offset for partial")
+	return readerSizeSyncliteral{r, count}
 }
 
 func (cmd *fetchCmd) xpartialReader(partial *partial, r io.Reader) io.Reader {
@@ -656,10 +669,9 @@ func (cmd *fetchCmd) xpartialReader(partial *partial, r io.Reader) io.Reader {
 }
 
 func (cmd *fetchCmd) xbody(a fetchAtt) (string, token) {
-	msgr, part := cmd.xensureParsed()
-
 	if a.section == nil {
 		// Non-extensible form of BODYSTRUCTURE.
+		_, part := cmd.xensureParsed()
 		return a.field, xbodystructure(part)
 	}
 
@@ -668,6 +680,8 @@ func (cmd *fetchCmd) xbody(a fetchAtt) (string, token) {
 	respField := cmd.sectionRespField(a)
 
 	if a.section.msgtext == nil && a.section.part == nil {
+		// Full message, no need to parse it.
+		msgr := cmd.xensureReader()
 		m := cmd.xensureMessage()
 		var offset int64
 		count := m.Size
@@ -684,7 +698,11 @@ func (cmd *fetchCmd) xbody(a fetchAtt) (string, token) {
 		return respField, readerSizeSyncliteral{&moxio.AtReader{R: msgr, Offset: offset}, count}
 	}
 
-	sr := cmd.xsection(a.section, part)
+	_, part := cmd.xensureParsed()
+	sr, size := cmd.xsection(a.section, part)
+	if size >= 0 {
+		return respField, cmd.xsizedLiteral(sr, size, a.partial)
+	}
 
 	if a.partial != nil {
 		n, err := io.Copy(io.Discard, io.LimitReader(sr, int64(a.partial.offset)))
@@ -697,6 +715,14 @@ func (cmd *fetchCmd) xbody(a fetchAtt) (string, token) {
 	return respField, readerSyncliteral{sr}
 }
 
+// rawSize returns the size of the raw body of p, or -1 if not known.
+func rawSize(p *message.Part) int64 {
+	if p.EndOffset < 0 {
+		return -1
+	}
+	return p.EndOffset - p.BodyOffset
+}
+
 func (cmd *fetchCmd) xpartnumsDeref(nums []uint32, p *message.Part) *message.Part {
 	// ../rfc/9051:4481
 	if (len(p.Parts) == 0 && p.Message == nil) && len(nums) == 1 && nums[0] == 1 {
@@ -719,7 +745,8 @@ func (cmd *fetchCmd) xpartnumsDeref(nums []uint32, p *message.Part) *message.Par
 	return p
 }
 
-func (cmd *fetchCmd) xsection(section *sectionSpec, p *message.Part) io.Reader {
+// xsection returns a reader for the section, and its size, or -1 if not known.
+func (cmd *fetchCmd) xsection(section *sectionSpec, p *message.Part) (io.Reader, int64) {
 	if section.part == nil {
 		return cmd.xsectionMsgtext(section.msgtext, p)
 	}
@@ -727,7 +754,7 @@ func (cmd *fetchCmd) xsection(section *sectionSpec, p *message.Part) io.Reader {
 	p = cmd.xpartnumsDeref(section.part.part, p)
 
 	if section.part.text == nil {
-		return p.RawReader()
+		return p.RawReader(), rawSize(p)
 	}
 
 	// ../rfc/9051:4535
@@ -766,25 +793,27 @@ func (cmd *fetchCmd) xsection(section *sectionSpec, p *message.Part) io.Reader {
 			hb.Write(line)
 		}
 	}
-	return hb
+	return hb, int64(hb.Len())
 }
 
-func (cmd *fetchCmd) xsectionMsgtext(smt *sectionMsgtext, p *message.Part) io.Reader {
+func (cmd *fetchCmd) xsectionMsgtext(smt *sectionMsgtext, p *message.Part) (io.Reader, int64) {
 	if smt.s == "HEADER" {
-		return p.HeaderReader()
+		return p.HeaderReader(), p.BodyOffset - p.HeaderOffset
 	}
 
 	switch smt.s {
 	case "HEADER.FIELDS":
-		return cmd.xmodifiedHeader(p, smt.headers, false)
+		hb := cmd.xmodifiedHeader(p, smt.headers, false)
+		return hb, int64(hb.Len())
 
 	case "HEADER.FIELDS.NOT":
-		return cmd.xmodifiedHeader(p, smt.headers, true)
+		hb := cmd.xmodifiedHeader(p, smt.headers, true)
+		return hb, int64(hb.Len())
 
 	case "TEXT":
 		// It appears imap clients expect to get the body of the message, not a "text body"
 		// which sounds like it means a text/* part of a message. ../rfc/9051:4517
-		return p.RawReader()
+		return p.RawReader(), rawSize(p)
 	}
 	panic(serverError{fmt.Errorf("missing case")})
 }
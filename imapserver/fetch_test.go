@@ -1,6 +1,8 @@
 package imapserver
 
 import (
+	"bytes"
+	"encoding/base64"
 	"strings"
 	"testing"
 	"time"
@@ -401,3 +403,36 @@ Content-Transfer-Encoding: Quoted-printable
 
 	tc.client.Logout()
 }
+
+// Benchmark fetching a message with a large attachment: the start of the full
+// message, and the end of the raw and decoded attachment part, in chunks like
+// clients fetch large attachments. The client refuses literals over 1MB.
+func BenchmarkFetch(b *testing.B) {
+	tc := start(b)
+	defer tc.close()
+
+	tc.client.Login("mjl@mox.example", password0)
+	tc.client.Enable("imap4rev2")
+
+	const size = 8 * 1024 * 1024
+	var msg strings.Builder
+	msg.WriteString("From: <mjl@mox.example>\r\nTo: <mjl@mox.example>\r\nSubject: attachment\r\nMIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=x\r\n\r\n--x\r\nContent-Type: text/plain\r\n\r\nsee attachment\r\n--x\r\nContent-Type: application/octet-stream\r\nContent-Transfer-Encoding: base64\r\n\r\n")
+	line := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{0xff}, 57))
+	for i := 0; i < size/57; i++ {
+		msg.WriteString(line + "\r\n")
+	}
+	msg.WriteString("--x--\r\n")
+	tc.client.Append("inbox", nil, nil, []byte(msg.String()))
+	tc.client.Select("inbox")
+
+	const chunk = 1000 * 1000
+	for _, att := range []string{"body.peek[]<0.1000000>", "body.peek[2]<10000000.1000000>", "binary.peek[2]<7000000.1000000>"} {
+		b.Run(att, func(b *testing.B) {
+			b.SetBytes(chunk)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				tc.transactf("ok", "fetch 1 %s", att)
+			}
+		})
+	}
+}
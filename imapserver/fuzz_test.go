@@ -133,7 +133,7 @@ func FuzzServer(f *testing.F) {
 
 			err = serverConn.SetDeadline(time.Now().Add(time.Second))
 			flog(err, "set server deadline")
-			serve("test", cid, nil, serverConn, false, true)
+			serve("test", cid, nil, serverConn, false, true, false, 0)
 			cid++
 		}
 
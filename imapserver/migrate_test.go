@@ -0,0 +1,132 @@
+package imapserver
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/imapmigrate"
+	"github.com/mjl-/mox/store"
+)
+
+func tcompare(t *testing.T, got, exp any) {
+	t.Helper()
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("got %#v, expected %#v", got, exp)
+	}
+}
+
+// Migrate from an account at our own IMAP server to another local account.
+func TestMigrate(t *testing.T) {
+	tc := start(t)
+	defer tc.close()
+
+	tc.client.Login("mjl@mox.example", password0)
+	tc.client.Create("Archive/2020")
+	tc.client.Create("Ünicode")
+	received := time.Date(2020, time.January, 1, 10, 0, 0, 0, time.UTC)
+	msg := func(s string) []byte {
+		return []byte("Subject: " + s + "\r\n\r\ntest\r\n")
+	}
+	tc.client.Append("inbox", []string{`\Seen`, "label1"}, &received, msg("inbox 1"))
+	tc.client.Append("inbox", nil, &received, msg("inbox 2"))
+	tc.client.Append("Archive/2020", []string{`\Flagged`}, &received, msg("archive"))
+	tc.client.Append("Ünicode", nil, &received, msg("unicode"))
+	tc.client.Append("Sent", []string{`\Seen`}, &received, msg("sent"))
+
+	acc, err := store.OpenAccount(pkglog, "other")
+	tcheck(t, err, "open account")
+	defer func() {
+		err := acc.Close()
+		tcheck(t, err, "close account")
+	}()
+
+	// Wait for connections to be closed, they close the account, before the test
+	// data is removed by a next test.
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{fakeCert(t)}}
+	remote := imapmigrate.Remote{
+		Host:      "localhost",
+		TLSMode:   imapmigrate.TLSImmediate,
+		Username:  "mjl@mox.example",
+		Password:  password0,
+		TLSConfig: &tls.Config{InsecureSkipVerify: true},
+		Dial: func(ctx context.Context, addr string) (net.Conn, error) {
+			serverConn, clientConn := net.Pipe()
+			connCounter++
+			cid := connCounter
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				serve("test", cid, tlsConfig, tls.Server(serverConn, tlsConfig), true, false, false, 0)
+			}()
+			return clientConn, nil
+		},
+	}
+
+	var problems []string
+	opts := imapmigrate.Options{Problem: func(msg string) { problems = append(problems, msg) }}
+	result, err := imapmigrate.Migrate(ctxbg, pkglog, acc, remote, opts)
+	tcheck(t, err, "migrate")
+	tcompare(t, problems, []string(nil))
+	tcompare(t, result.Messages, 5)
+	tcompare(t, result.Skipped, 0)
+
+	mailboxMessages := func(name string) []store.Message {
+		t.Helper()
+		mb, err := bstore.QueryDB[store.Mailbox](ctxbg, acc.DB).FilterNonzero(store.Mailbox{Name: name}).Get()
+		tcheck(t, err, "get mailbox "+name)
+		l, err := bstore.QueryDB[store.Message](ctxbg, acc.DB).FilterNonzero(store.Message{MailboxID: mb.ID}).FilterEqual("Expunged", false).SortAsc("UID").List()
+		tcheck(t, err, "list messages")
+		return l
+	}
+	inbox := mailboxMessages("Inbox")
+	tcompare(t, len(inbox), 2)
+	tcompare(t, inbox[0].Seen, true)
+	tcompare(t, inbox[0].Keywords, []string{"label1"})
+	tcompare(t, inbox[0].Received.Equal(received), true)
+	archive := mailboxMessages("Archive/2020")
+	tcompare(t, len(archive), 1)
+	tcompare(t, archive[0].Flagged, true)
+	tcompare(t, len(mailboxMessages("Ünicode")), 1)
+	tcompare(t, len(mailboxMessages("Sent")), 1)
+
+	// Delta pass: new message, changed flags.
+	tc.client.Append("inbox", nil, &received, msg("inbox 3"))
+	tc.client.Select("inbox")
+	tc.client.StoreFlagsClear("1", true, `\Seen`)
+	tc.client.StoreFlagsAdd("2", true, `\Answered`)
+
+	result, err = imapmigrate.Migrate(ctxbg, pkglog, acc, remote, opts)
+	tcheck(t, err, "migrate")
+	tcompare(t, problems, []string(nil))
+	tcompare(t, result.Messages, 1)
+	tcompare(t, result.Flags, 2)
+	inbox = mailboxMessages("Inbox")
+	tcompare(t, len(inbox), 3)
+	tcompare(t, inbox[0].Seen, false)
+	tcompare(t, inbox[1].Answered, true)
+
+	// New migration state for the same remote account, e.g. after uidvalidity
+	// changed, links to messages already present.
+	err = acc.DB.Write(ctxbg, func(tx *bstore.Tx) error {
+		if _, err := bstore.QueryTx[store.MigrationMessage](tx).Delete(); err != nil {
+			return err
+		}
+		_, err := bstore.QueryTx[store.MigrationMailbox](tx).Delete()
+		return err
+	})
+	tcheck(t, err, "removing migration state")
+	result, err = imapmigrate.Migrate(ctxbg, pkglog, acc, remote, opts)
+	tcheck(t, err, "migrate")
+	tcompare(t, result.Messages, 0)
+	tcompare(t, result.Skipped, 6)
+}
@@ -110,8 +110,11 @@ func (t readerSizeSyncliteral) pack(c *conn) string {
 func (t readerSizeSyncliteral) writeTo(c *conn, w io.Writer) {
 	fmt.Fprintf(w, "{%d}\r\n", t.size)
 	defer c.xtrace(mlog.LevelTracedata)()
-	if _, err := io.Copy(w, io.LimitReader(t.r, t.size)); err != nil {
+	if n, err := io.Copy(w, io.LimitReader(t.r, t.size)); err != nil {
 		panic(err)
+	} else if n != t.size {
+		// We can't recover, the literal has been announced with its size.
+		panic(fmt.Errorf("short literal, wrote %d bytes, expected %d", n, t.size))
 	}
 }
 
@@ -7,6 +7,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/mjl-/mox/imapclient"
 )
 
 var (
@@ -399,7 +401,7 @@ func (p *parser) xdecodeMailbox(s string) string {
 	if p.conn.utf8strings() {
 		return s
 	}
-	ns, err := utf7decode(s)
+	ns, err := imapclient.UTF7Decode(s)
 	if err != nil {
 		p.xerrorf("decoding utf7 mailbox name: %v", err)
 	}
@@ -758,6 +760,12 @@ func (p *parser) xliteralSize(maxSize int64, lit8 bool) (size int64, sync bool)
 	sync = !p.take("+")
 	p.xtake("}")
 	p.xempty()
+	// With LITERAL-, non-synchronizing literals are limited to 4096 bytes. The client
+	// is already sending the data, so the connection will be aborted. ../rfc/7888:190
+	if !sync && p.conn != nil && p.conn.maxMessageSize > 0 && size > 4096 {
+		err := errors.New("non-synchronizing literal too big")
+		panic(syntaxError{"", "TOOBIG", err.Error(), err})
+	}
 	return size, sync
 }
 
@@ -29,8 +29,18 @@ func TestQuota1(t *testing.T) {
 	tc.transactf("ok", `getquota ""`)
 	tc.xuntagged()
 
-	// Check that we get a DELETED-STORAGE status attribute with value 0, also if
-	// messages are marked deleted. We don't go through the trouble.
+	// Check that we get a DELETED-STORAGE status attribute with the size of messages
+	// marked deleted.
+	tc.transactf("ok", "status inbox (DELETED-STORAGE)")
+	tc.xuntagged(imapclient.UntaggedStatus{Mailbox: "Inbox", Attrs: map[imapclient.StatusAttr]int64{imapclient.StatusDeletedStorage: 0}})
+
+	tc.client.Append("inbox", nil, nil, []byte(exampleMsg))
+	tc.client.Append("inbox", nil, nil, []byte(exampleMsg))
+	tc.client.Select("inbox")
+	tc.transactf("ok", `store 1 +flags (\Deleted)`)
+	tc.transactf("ok", "status inbox (DELETED-STORAGE)")
+	tc.xuntagged(imapclient.UntaggedStatus{Mailbox: "Inbox", Attrs: map[imapclient.StatusAttr]int64{imapclient.StatusDeletedStorage: int64(len(exampleMsg))}})
+	tc.transactf("ok", "expunge")
 	tc.transactf("ok", "status inbox (DELETED-STORAGE)")
 	tc.xuntagged(imapclient.UntaggedStatus{Mailbox: "Inbox", Attrs: map[imapclient.StatusAttr]int64{imapclient.StatusDeletedStorage: 0}})
 
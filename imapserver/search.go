@@ -363,7 +363,7 @@ func (s *search) xensurePart() bool {
 	// Closed by searchMatch after all (recursive) search.match calls are finished.
 	s.mr = s.c.account.MessageReader(s.m)
 
-	if s.m.ParsedBuf == nil {
+	if s.m.Sealed.ParsedBuf == nil {
 		s.c.log.Error("missing parsed message")
 		return false
 	}
@@ -38,6 +38,7 @@ import (
 	"crypto/md5"
 	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/sha512"
 	"crypto/tls"
 	"encoding/base64"
 	"errors"
@@ -66,15 +67,19 @@ import (
 	"github.com/mjl-/bstore"
 
 	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/imapclient"
 	"github.com/mjl-/mox/message"
 	"github.com/mjl-/mox/metrics"
 	"github.com/mjl-/mox/mlog"
 	"github.com/mjl-/mox/mox-"
 	"github.com/mjl-/mox/moxio"
 	"github.com/mjl-/mox/moxvar"
+	"github.com/mjl-/mox/oidc"
 	"github.com/mjl-/mox/ratelimit"
+	"github.com/mjl-/mox/sasl"
 	"github.com/mjl-/mox/scram"
 	"github.com/mjl-/mox/store"
+	"github.com/mjl-/mox/tlsfp"
 )
 
 var (
@@ -133,7 +138,7 @@ var authFailDelay = time.Second  // After authentication failure.
 
 // Capabilities (extensions) the server supports. Connections will add a few more, e.g. STARTTLS, LOGINDISABLED, AUTH=PLAIN.
 // ENABLE: ../rfc/5161
-// LITERAL+: ../rfc/7888
+// LITERAL+ or LITERAL-: ../rfc/7888
 // IDLE: ../rfc/2177
 // SASL-IR: ../rfc/4959
 // BINARY: ../rfc/3516
@@ -147,10 +152,13 @@ var authFailDelay = time.Second  // After authentication failure.
 // SPECIAL-USE: ../rfc/6154
 // LIST-STATUS: ../rfc/5819
 // ID: ../rfc/2971
+// AUTH=SCRAM-SHA-512-PLUS and AUTH=SCRAM-SHA-512: draft-ietf-kitten-scram-sha-512
 // AUTH=SCRAM-SHA-256-PLUS and AUTH=SCRAM-SHA-256: ../rfc/7677 ../rfc/5802
 // AUTH=SCRAM-SHA-1-PLUS and AUTH=SCRAM-SHA-1: ../rfc/5802
 // AUTH=CRAM-MD5: ../rfc/2195
-// APPENDLIMIT, we support the max possible size, 1<<63 - 1: ../rfc/7889:129
+// AUTH=OAUTHBEARER and AUTH=XOAUTH2, only with OIDC configured: RFC 7628
+// AUTH=EXTERNAL, only with TLS client authentication configured: RFC 4422
+// APPENDLIMIT, the configured maximum, or the max possible size 1<<63 - 1: ../rfc/7889:129
 // CONDSTORE: ../rfc/7162:411
 // QRESYNC: ../rfc/7162:1323
 // STATUS=SIZE: ../rfc/8438 ../rfc/9051:8024
@@ -160,12 +168,14 @@ var authFailDelay = time.Second  // After authentication failure.
 // TLS. The client should not be selecting PLUS variants on non-TLS connections,
 // instead opting to do the bare SCRAM variant without indicating the server claims
 // to support the PLUS variant (skipping the server downgrade detection check).
-const serverCapabilities = "IMAP4rev2 IMAP4rev1 ENABLE LITERAL+ IDLE SASL-IR BINARY UNSELECT UIDPLUS ESEARCH SEARCHRES MOVE UTF8=ACCEPT LIST-EXTENDED SPECIAL-USE LIST-STATUS AUTH=SCRAM-SHA-256-PLUS AUTH=SCRAM-SHA-256 AUTH=SCRAM-SHA-1-PLUS AUTH=SCRAM-SHA-1 AUTH=CRAM-MD5 ID APPENDLIMIT=9223372036854775807 CONDSTORE QRESYNC STATUS=SIZE QUOTA QUOTA=RES-STORAGE"
+const serverCapabilities = "IMAP4rev2 IMAP4rev1 ENABLE IDLE SASL-IR BINARY UNSELECT UIDPLUS ESEARCH SEARCHRES MOVE UTF8=ACCEPT LIST-EXTENDED SPECIAL-USE LIST-STATUS AUTH=SCRAM-SHA-512-PLUS AUTH=SCRAM-SHA-512 AUTH=SCRAM-SHA-256-PLUS AUTH=SCRAM-SHA-256 AUTH=SCRAM-SHA-1-PLUS AUTH=SCRAM-SHA-1 AUTH=CRAM-MD5 ID CONDSTORE QRESYNC STATUS=SIZE QUOTA QUOTA=RES-STORAGE"
 
 type conn struct {
 	cid               int64
 	state             state
 	conn              net.Conn
+	origConn          net.Conn           // Underlying connection, without TLS. For closing the connection forcibly.
+	protocol          string             // "imap" or "imaps", for sessions.
 	tls               bool               // Whether TLS has been initialized.
 	br                *bufio.Reader      // From remote, with TLS unwrapped in case of TLS.
 	line              chan lineErr       // If set, instead of reading from br, a line is read from this channel. For reading a line in IDLE while also waiting for mailbox/account updates.
@@ -177,7 +187,10 @@ type conn struct {
 	lastlog           time.Time   // For printing time since previous log line.
 	tlsConfig         *tls.Config // TLS config to use for handshake.
 	remoteIP          net.IP
+	listenerName      string
 	noRequireSTARTTLS bool
+	noPlaintextAuth   bool   // Whether IMAP LOGIN and AUTHENTICATE PLAIN are disabled.
+	maxMessageSize    int64  // For APPEND. If > 0, LITERAL- is announced instead of LITERAL+.
 	cmd               string // Currently executing, for deciding to applyChanges and logging.
 	cmdMetric         string // Currently executing, for metrics.
 	cmdStart          time.Time
@@ -198,6 +211,8 @@ type conn struct {
 	username   string // Full username as used during login.
 	account    *store.Account
 	comm       *store.Comm // For sending/receiving changes on mailboxes in account, e.g. from messages incoming on smtp, or another imap client.
+	sessionID  int64       // Of store.ProtocolSession, for listing and closing sessions.
+	clientID   string      // From ID command, for sessions.
 
 	mailboxID int64       // Only for StateSelected.
 	readonly  bool        // If opened mailbox is readonly.
@@ -321,19 +336,22 @@ func Listen() {
 		var tlsConfig *tls.Config
 		if listener.TLS != nil {
 			tlsConfig = listener.TLS.Config
+			if listener.TLS.ClientAuthConfig != nil {
+				tlsConfig = listener.TLS.ClientAuthConfig
+			}
 		}
 
 		if listener.IMAP.Enabled {
 			port := config.Port(listener.IMAP.Port, 143)
 			for _, ip := range listener.IPs {
-				listen1("imap", name, ip, port, tlsConfig, false, listener.IMAP.NoRequireSTARTTLS)
+				listen1("imap", name, ip, port, tlsConfig, false, listener.IMAP.NoRequireSTARTTLS, listener.NoPlaintextAuth, listener.IMAPMaxMessageSize)
 			}
 		}
 
 		if listener.IMAPS.Enabled {
 			port := config.Port(listener.IMAPS.Port, 993)
 			for _, ip := range listener.IPs {
-				listen1("imaps", name, ip, port, tlsConfig, true, false)
+				listen1("imaps", name, ip, port, tlsConfig, true, false, listener.NoPlaintextAuth, listener.IMAPMaxMessageSize)
 			}
 		}
 	}
@@ -341,7 +359,7 @@ func Listen() {
 
 var servers []func()
 
-func listen1(protocol, listenerName, ip string, port int, tlsConfig *tls.Config, xtls, noRequireSTARTTLS bool) {
+func listen1(protocol, listenerName, ip string, port int, tlsConfig *tls.Config, xtls, noRequireSTARTTLS, noPlaintextAuth bool, maxMessageSize int64) {
 	log := mlog.New("imapserver", nil)
 	addr := net.JoinHostPort(ip, fmt.Sprintf("%d", port))
 	if os.Getuid() == 0 {
@@ -355,8 +373,10 @@ func listen1(protocol, listenerName, ip string, port int, tlsConfig *tls.Config,
 	if err != nil {
 		log.Fatalx("imap: listen for imap", err, slog.String("protocol", protocol), slog.String("listener", listenerName))
 	}
+	// Connections are wrapped in a tlsfp.Conn to fingerprint TLS clients.
+	tlsConfig = mox.TLSFingerprintConfig(tlsConfig, protocol)
 	if xtls {
-		ln = tls.NewListener(ln, tlsConfig)
+		ln = tls.NewListener(tlsfp.NewListener(ln), tlsConfig)
 	}
 
 	serve := func() {
@@ -368,7 +388,7 @@ func listen1(protocol, listenerName, ip string, port int, tlsConfig *tls.Config,
 			}
 
 			metricIMAPConnection.WithLabelValues(protocol).Inc()
-			go serve(listenerName, mox.Cid(), tlsConfig, conn, xtls, noRequireSTARTTLS)
+			go serve(listenerName, mox.Cid(), tlsConfig, conn, xtls, noRequireSTARTTLS, noPlaintextAuth, maxMessageSize)
 		}
 	}
 
@@ -392,7 +412,7 @@ func (c *conn) encodeMailbox(s string) string {
 	if c.utf8strings() {
 		return s
 	}
-	return utf7encode(s)
+	return imapclient.UTF7Encode(s)
 }
 
 func (c *conn) xdbwrite(fn func(tx *bstore.Tx)) {
@@ -623,7 +643,7 @@ func (c *conn) xhighestModSeq(tx *bstore.Tx, mailboxID int64) store.ModSeq {
 
 var cleanClose struct{} // Sentinel value for panic/recover indicating clean close of connection.
 
-func serve(listenerName string, cid int64, tlsConfig *tls.Config, nc net.Conn, xtls, noRequireSTARTTLS bool) {
+func serve(listenerName string, cid int64, tlsConfig *tls.Config, nc net.Conn, xtls, noRequireSTARTTLS, noPlaintextAuth bool, maxMessageSize int64) {
 	var remoteIP net.IP
 	if a, ok := nc.RemoteAddr().(*net.TCPAddr); ok {
 		remoteIP = a.IP
@@ -632,14 +652,24 @@ func serve(listenerName string, cid int64, tlsConfig *tls.Config, nc net.Conn, x
 		remoteIP = net.ParseIP("127.0.0.10")
 	}
 
+	protocol := "imap"
+	if xtls {
+		protocol = "imaps"
+	}
+
 	c := &conn{
 		cid:               cid,
 		conn:              nc,
+		origConn:          nc,
+		protocol:          protocol,
 		tls:               xtls,
 		lastlog:           time.Now(),
 		tlsConfig:         tlsConfig,
 		remoteIP:          remoteIP,
+		listenerName:      listenerName,
 		noRequireSTARTTLS: noRequireSTARTTLS,
+		noPlaintextAuth:   noPlaintextAuth,
+		maxMessageSize:    maxMessageSize,
 		enabled:           map[capability]bool{},
 		cmd:               "(greeting)",
 		cmdStart:          time.Now(),
@@ -668,11 +698,13 @@ func serve(listenerName string, cid int64, tlsConfig *tls.Config, nc net.Conn, x
 	// Many IMAP connections use IDLE to wait for new incoming messages. We'll enable
 	// keepalive to get a higher chance of the connection staying alive, or otherwise
 	// detecting broken connections early.
-	xconn := c.conn
 	if xtls {
-		xconn = c.conn.(*tls.Conn).NetConn()
+		c.origConn = c.conn.(*tls.Conn).NetConn()
+		if fc, ok := c.origConn.(*tlsfp.Conn); ok {
+			c.origConn = fc.NetConn()
+		}
 	}
-	if tcpconn, ok := xconn.(*net.TCPConn); ok {
+	if tcpconn, ok := c.origConn.(*net.TCPConn); ok {
 		if err := tcpconn.SetKeepAlivePeriod(5 * time.Minute); err != nil {
 			c.log.Errorx("setting keepalive period", err)
 		} else if err := tcpconn.SetKeepAlive(true); err != nil {
@@ -690,6 +722,10 @@ func serve(listenerName string, cid int64, tlsConfig *tls.Config, nc net.Conn, x
 		c.conn.Close()
 
 		if c.account != nil {
+			if c.sessionID != 0 {
+				err := c.account.ProtocolSessionEnd(context.TODO(), c.sessionID)
+				c.log.Check(err, "marking session as ended")
+			}
 			c.comm.Unregister()
 			err := c.account.Close()
 			c.xsanity(err, "close account")
@@ -742,6 +778,20 @@ func serve(listenerName string, cid int64, tlsConfig *tls.Config, nc net.Conn, x
 	mox.Connections.Register(nc, "imap", listenerName)
 	defer mox.Connections.Unregister(nc)
 
+	if xtls {
+		// Handshake before the greeting, for logging the TLS client fingerprint.
+		tlsConn := c.conn.(*tls.Conn)
+		cidctx := context.WithValue(mox.Context, mlog.CidKey, c.cid)
+		ctx, cancel := context.WithTimeout(cidctx, time.Minute)
+		err := tlsConn.HandshakeContext(ctx)
+		cancel()
+		mox.TLSFingerprintLog(c.log, c.protocol, tlsConn.NetConn())
+		if err != nil {
+			c.log.Infox("tls handshake", err)
+			return
+		}
+	}
+
 	c.writelinef("* OK [CAPABILITY %s] mox imap", c.capabilities())
 
 	for {
@@ -864,10 +914,14 @@ func (c *conn) command() {
 
 	fn := commands[cmdlow]
 	if fn == nil {
+		mox.AutoBanFailure(c.log, c.remoteIP, mox.AutoBanProtocol)
 		xsyntaxErrorf("unknown command %q", cmd)
 	}
 	c.cmdMetric = c.cmd
 	c.ncmds++
+	if c.sessionID != 0 {
+		c.account.ProtocolSessionActivity(c.sessionID)
+	}
 
 	// Check if command is allowed in this state.
 	if _, ok1 := commandsStateAny[cmdlow]; ok1 {
@@ -982,7 +1036,7 @@ func (c *conn) xsequence(uid store.UID) msgseq {
 func (c *conn) sequenceRemove(seq msgseq, uid store.UID) {
 	i := seq - 1
 	if c.uids[i] != uid {
-		xserverErrorf(fmt.Sprintf("got uid %d at msgseq %d, expected uid %d", uid, seq, c.uids[i]))
+		xserverErrorf("got uid %d at msgseq %d, expected uid %d", uid, seq, c.uids[i])
 	}
 	copy(c.uids[i:], c.uids[i+1:])
 	c.uids = c.uids[:len(c.uids)-1]
@@ -1359,13 +1413,33 @@ func (c *conn) cmdCapability(tag, cmd string, p *parser) {
 // For use in cmdCapability and untagged OK responses on connection start, login and authenticate.
 func (c *conn) capabilities() string {
 	caps := serverCapabilities
+	// With a maximum message size, we announce LITERAL- instead of LITERAL+, so
+	// clients use synchronizing literals for large messages and we can reject them
+	// before they are transferred. ../rfc/7888:183 ../rfc/7889:143
+	if c.maxMessageSize > 0 {
+		caps += fmt.Sprintf(" LITERAL- APPENDLIMIT=%d", c.maxMessageSize)
+	} else {
+		caps += " LITERAL+ APPENDLIMIT=9223372036854775807"
+	}
 	// ../rfc/9051:1238
 	// We only allow starting without TLS when explicitly configured, in violation of RFC.
 	if !c.tls && c.tlsConfig != nil {
 		caps += " STARTTLS"
 	}
-	if c.tls || c.noRequireSTARTTLS {
+	if c.tls && c.tlsConfig.ClientAuth != tls.NoClientCert {
+		caps += " AUTH=EXTERNAL"
+	}
+	if (c.tls || c.noRequireSTARTTLS) && c.noPlaintextAuth {
+		// Only mechanisms that don't send the password.
+		caps += " LOGINDISABLED"
+		if mox.Conf.Static.OIDC != nil {
+			caps += " AUTH=OAUTHBEARER AUTH=XOAUTH2"
+		}
+	} else if c.tls || c.noRequireSTARTTLS {
 		caps += " AUTH=PLAIN"
+		if mox.Conf.Static.OIDC != nil {
+			caps += " AUTH=OAUTHBEARER AUTH=XOAUTH2"
+		}
 	} else {
 		caps += " LOGINDISABLED"
 	}
@@ -1430,8 +1504,13 @@ func (c *conn) cmdID(tag, cmd string, p *parser) {
 	}
 	p.xempty()
 
-	// We just log the client id.
+	// We log the client id, and keep it for the list of sessions.
 	c.log.Info("client id", slog.Any("params", params))
+	c.clientID = strings.TrimSpace(params["name"] + " " + params["version"])
+	if c.sessionID != 0 {
+		err := c.account.ProtocolSessionClientID(context.TODO(), c.sessionID, c.clientID)
+		c.log.Check(err, "setting client id for session")
+	}
 
 	// Response syntax: ../rfc/2971:243
 	// We send our name and version. ../rfc/2971:193
@@ -1468,9 +1547,12 @@ func (c *conn) cmdStarttls(tag, cmd string, p *parser) {
 	cidctx := context.WithValue(mox.Context, mlog.CidKey, c.cid)
 	ctx, cancel := context.WithTimeout(cidctx, time.Minute)
 	defer cancel()
-	tlsConn := tls.Server(conn, c.tlsConfig)
+	fpConn := tlsfp.NewConn(conn)
+	tlsConn := tls.Server(fpConn, c.tlsConfig)
 	c.log.Debug("starting tls server handshake")
-	if err := tlsConn.HandshakeContext(ctx); err != nil {
+	err := tlsConn.HandshakeContext(ctx)
+	mox.TLSFingerprintLog(c.log, c.protocol, fpConn)
+	if err != nil {
 		panic(fmt.Errorf("starttls handshake: %s (%w)", err, errIO))
 	}
 	cancel()
@@ -1520,10 +1602,12 @@ func (c *conn) cmdAuthenticate(tag, cmd string, p *parser) {
 	authResult := "error"
 	defer func() {
 		metrics.AuthenticationInc("imap", authVariant, authResult)
+		metrics.AuthenticationListenerInc("imap", c.listenerName, authResult)
 		if authResult == "ok" {
 			mox.LimiterFailedAuth.Reset(c.remoteIP, time.Now())
 		} else if !missingDerivedSecrets {
 			mox.LimiterFailedAuth.Add(c.remoteIP, time.Now(), 1)
+			mox.AutoBanFailure(c.log, c.remoteIP, mox.AutoBanAuth)
 		}
 	}()
 
@@ -1574,6 +1658,9 @@ func (c *conn) cmdAuthenticate(tag, cmd string, p *parser) {
 	case "PLAIN":
 		authVariant = "plain"
 
+		if c.noPlaintextAuth {
+			xuserErrorf("authentication with plain text password disabled on this listener, use scram")
+		}
 		if !c.noRequireSTARTTLS && !c.tls {
 			// ../rfc/9051:5194
 			xusercodeErrorf("PRIVACYREQUIRED", "tls required for login")
@@ -1595,7 +1682,7 @@ func (c *conn) cmdAuthenticate(tag, cmd string, p *parser) {
 			xusercodeErrorf("AUTHORIZATIONFAILED", "cannot assume role")
 		}
 
-		acc, err := store.OpenEmailAuth(c.log, authc, password)
+		acc, err := store.OpenEmailAuthProtocol(c.log, authc, password, "imap", c.remoteIP)
 		if err != nil {
 			if errors.Is(err, store.ErrUnknownCredentials) {
 				authResult = "badcreds"
@@ -1624,7 +1711,12 @@ func (c *conn) cmdAuthenticate(tag, cmd string, p *parser) {
 		}
 		addr := t[0]
 		c.log.Debug("cram-md5 auth", slog.String("address", addr))
-		acc, _, err := store.OpenEmail(c.log, addr)
+		if _, auth := store.ExternalAuth(addr); auth != nil {
+			// No locally stored secrets with external authentication.
+			c.log.Info("failed authentication attempt, cram-md5 not possible with external authentication", slog.String("username", addr), slog.Any("remote", c.remoteIP))
+			xusercodeErrorf("AUTHENTICATIONFAILED", "bad credentials")
+		}
+		acc, _, err := store.OpenEmailLogin(c.log, addr)
 		if err != nil {
 			if errors.Is(err, store.ErrUnknownCredentials) {
 				c.log.Info("failed authentication attempt", slog.String("username", addr), slog.Any("remote", c.remoteIP))
@@ -1646,6 +1738,16 @@ func (c *conn) cmdAuthenticate(tag, cmd string, p *parser) {
 					c.log.Info("failed authentication attempt", slog.String("username", addr), slog.Any("remote", c.remoteIP))
 					xusercodeErrorf("AUTHENTICATIONFAILED", "bad credentials")
 				}
+				if err == nil {
+					// With two-factor authentication, only app passwords can be used, which don't
+					// have derived secrets for CRAM-MD5 and SCRAM.
+					if totpEnabled, err := store.TOTPEnabledTx(tx); err != nil {
+						return err
+					} else if totpEnabled {
+						c.log.Info("failed authentication attempt, two-factor authentication enabled, app password required", slog.String("username", addr), slog.Any("remote", c.remoteIP))
+						xusercodeErrorf("AUTHENTICATIONFAILED", "bad credentials")
+					}
+				}
 				if err != nil {
 					return err
 				}
@@ -1676,7 +1778,7 @@ func (c *conn) cmdAuthenticate(tag, cmd string, p *parser) {
 		acc = nil // Cancel cleanup.
 		c.username = addr
 
-	case "SCRAM-SHA-256-PLUS", "SCRAM-SHA-256", "SCRAM-SHA-1-PLUS", "SCRAM-SHA-1":
+	case "SCRAM-SHA-512-PLUS", "SCRAM-SHA-512", "SCRAM-SHA-256-PLUS", "SCRAM-SHA-256", "SCRAM-SHA-1-PLUS", "SCRAM-SHA-1":
 		// todo: improve handling of errors during scram. e.g. invalid parameters. should we abort the imap command, or continue until the end and respond with a scram-level error?
 		// todo: use single implementation between ../imapserver/server.go and ../smtpserver/server.go
 
@@ -1689,6 +1791,8 @@ func (c *conn) cmdAuthenticate(tag, cmd string, p *parser) {
 			h = sha1.New
 		case "scram-sha-256", "scram-sha-256-plus":
 			h = sha256.New
+		case "scram-sha-512", "scram-sha-512-plus":
+			h = sha512.New
 		default:
 			xserverErrorf("missing case for scram variant")
 		}
@@ -1708,7 +1812,12 @@ func (c *conn) cmdAuthenticate(tag, cmd string, p *parser) {
 			xsyntaxErrorf("starting scram: %s", err)
 		}
 		c.log.Debug("scram auth", slog.String("authentication", ss.Authentication))
-		acc, _, err := store.OpenEmail(c.log, ss.Authentication)
+		if _, auth := store.ExternalAuth(ss.Authentication); auth != nil {
+			// No locally stored secrets with external authentication.
+			c.log.Info("failed authentication attempt, scram not possible with external authentication", slog.String("username", ss.Authentication), slog.Any("remote", c.remoteIP))
+			xuserErrorf("scram not possible")
+		}
+		acc, _, err := store.OpenEmailLogin(c.log, ss.Authentication)
 		if err != nil {
 			// todo: we could continue scram with a generated salt, deterministically generated
 			// from the username. that way we don't have to store anything but attackers cannot
@@ -1732,12 +1841,24 @@ func (c *conn) cmdAuthenticate(tag, cmd string, p *parser) {
 					c.log.Info("failed authentication attempt", slog.String("username", ss.Authentication), slog.Any("remote", c.remoteIP))
 					xusercodeErrorf("AUTHENTICATIONFAILED", "bad credentials")
 				}
+				if err == nil {
+					// With two-factor authentication, only app passwords can be used, which don't
+					// have derived secrets for CRAM-MD5 and SCRAM.
+					if totpEnabled, err := store.TOTPEnabledTx(tx); err != nil {
+						return err
+					} else if totpEnabled {
+						c.log.Info("failed authentication attempt, two-factor authentication enabled, app password required", slog.String("username", ss.Authentication), slog.Any("remote", c.remoteIP))
+						xusercodeErrorf("AUTHENTICATIONFAILED", "bad credentials")
+					}
+				}
 				xcheckf(err, "fetching credentials")
 				switch authVariant {
 				case "scram-sha-1", "scram-sha-1-plus":
 					xscram = password.SCRAMSHA1
 				case "scram-sha-256", "scram-sha-256-plus":
 					xscram = password.SCRAMSHA256
+				case "scram-sha-512", "scram-sha-512-plus":
+					xscram = password.SCRAMSHA512
 				default:
 					xserverErrorf("missing case for scram credentials")
 				}
@@ -1776,6 +1897,75 @@ func (c *conn) cmdAuthenticate(tag, cmd string, p *parser) {
 		acc = nil // Cancel cleanup.
 		c.username = ss.Authentication
 
+	case "OAUTHBEARER", "XOAUTH2":
+		authVariant = strings.ToLower(authType)
+
+		provider := oidc.Get(mox.Conf.Static.OIDC)
+		if provider == nil {
+			xuserErrorf("method not supported")
+		}
+		if !c.noRequireSTARTTLS && !c.tls {
+			// ../rfc/9051:5194
+			xusercodeErrorf("PRIVACYREQUIRED", "tls required for login")
+		}
+
+		// Bearer tokens are credentials, mark as traceauth.
+		defer c.xtrace(mlog.LevelTraceauth)()
+		buf := xreadInitial()
+		c.xtrace(mlog.LevelTrace) // Restore.
+		var username, token string
+		var err error
+		if authVariant == "oauthbearer" {
+			username, token, err = sasl.ParseOAuthBearer(buf)
+		} else {
+			username, token, err = sasl.ParseXOAuth2(buf)
+		}
+		if err != nil {
+			xsyntaxErrorf("%s", err)
+		}
+
+		acc, loginAddress, err := store.OpenEmailToken(c.log, token, username)
+		if err != nil {
+			if errors.Is(err, store.ErrUnknownCredentials) {
+				authResult = "badcreds"
+				c.log.Info("authentication failed", slog.String("username", username))
+				// The client must respond to the error details before we fail, RFC 7628
+				// section 3.2.3. XOAUTH2 works the same.
+				c.writelinef("+ %s", base64.StdEncoding.EncodeToString(sasl.OAuthErrorChallenge(provider.ConfigurationURL())))
+				xreadContinuation()
+				xusercodeErrorf("AUTHENTICATIONFAILED", "bad credentials")
+			}
+			xusercodeErrorf("", "error")
+		}
+		c.account = acc
+		c.username = loginAddress
+
+	case "EXTERNAL":
+		// TLS client certificate, verified during the handshake. RFC 4422 appendix A.
+		authVariant = "external"
+
+		if !c.tls || c.tlsConfig.ClientAuth == tls.NoClientCert {
+			xuserErrorf("method not supported")
+		}
+		authz := string(xreadInitial())
+		cs := c.conn.(*tls.Conn).ConnectionState()
+		if len(cs.VerifiedChains) == 0 {
+			authResult = "badcreds"
+			c.log.Info("authentication failed, no client certificate", slog.String("username", authz))
+			xusercodeErrorf("AUTHENTICATIONFAILED", "no client certificate")
+		}
+		acc, loginAddress, err := store.OpenTLSClientCert(c.log, cs.PeerCertificates[0], authz)
+		if err != nil {
+			if errors.Is(err, store.ErrUnknownCredentials) {
+				authResult = "badcreds"
+				c.log.Info("authentication failed", slog.String("username", authz))
+				xusercodeErrorf("AUTHENTICATIONFAILED", "bad credentials")
+			}
+			xusercodeErrorf("", "error")
+		}
+		c.account = acc
+		c.username = loginAddress
+
 	default:
 		xuserErrorf("method not supported")
 	}
@@ -1785,6 +1975,7 @@ func (c *conn) cmdAuthenticate(tag, cmd string, p *parser) {
 	c.authFailed = 0
 	c.comm = store.RegisterComm(c.account)
 	c.state = stateAuthenticated
+	c.sessionStart()
 	c.writeresultf("%s OK [CAPABILITY %s] authenticate done", tag, c.capabilities())
 }
 
@@ -1797,6 +1988,10 @@ func (c *conn) cmdLogin(tag, cmd string, p *parser) {
 	authResult := "error"
 	defer func() {
 		metrics.AuthenticationInc("imap", "login", authResult)
+		metrics.AuthenticationListenerInc("imap", c.listenerName, authResult)
+		if authResult == "badcreds" {
+			mox.AutoBanFailure(c.log, c.remoteIP, mox.AutoBanAuth)
+		}
 	}()
 
 	// todo: get this line logged with traceauth. the plaintext password is included on the command line, which we've already read (before dispatching to this function).
@@ -1808,6 +2003,9 @@ func (c *conn) cmdLogin(tag, cmd string, p *parser) {
 	password := p.xastring()
 	p.xempty()
 
+	if c.noPlaintextAuth {
+		xuserErrorf("login disabled on this listener, use authenticate with scram")
+	}
 	if !c.noRequireSTARTTLS && !c.tls {
 		// ../rfc/9051:5194
 		xusercodeErrorf("PRIVACYREQUIRED", "tls required for login")
@@ -1826,7 +2024,7 @@ func (c *conn) cmdLogin(tag, cmd string, p *parser) {
 		}
 	}()
 
-	acc, err := store.OpenEmailAuth(c.log, userid, password)
+	acc, err := store.OpenEmailAuthProtocol(c.log, userid, password, "imap", c.remoteIP)
 	if err != nil {
 		authResult = "badcreds"
 		var code string
@@ -1842,10 +2040,25 @@ func (c *conn) cmdLogin(tag, cmd string, p *parser) {
 	c.setSlow(false)
 	c.comm = store.RegisterComm(acc)
 	c.state = stateAuthenticated
+	c.sessionStart()
 	authResult = "ok"
 	c.writeresultf("%s OK [CAPABILITY %s] login done", tag, c.capabilities())
 }
 
+// sessionStart records the newly authenticated session, so the user and admin can
+// see it, and close it.
+func (c *conn) sessionStart() {
+	close := func() {
+		// We close the underlying connection. Closing a TLS connection could block on
+		// writing. Reads in the connection goroutine will fail and clean up.
+		err := c.origConn.Close()
+		c.log.Check(err, "closing connection for session")
+	}
+	var err error
+	c.sessionID, err = c.account.ProtocolSessionStart(context.TODO(), c.protocol, c.username, c.remoteIP, c.clientID, close)
+	c.log.Check(err, "recording session")
+}
+
 // Enable explicitly opts in to an extension. A server can typically send new kinds
 // of responses to a client. Most extensions do not require an ENABLE because a
 // client implicitly opts in to new response syntax by making a requests that uses
@@ -2010,41 +2223,58 @@ func (c *conn) cmdSelectExamine(isselect bool, tag, cmd string, p *parser) {
 	var highDeletedModSeq store.ModSeq
 	var firstUnseen msgseq = 0
 	var mb store.Mailbox
-	c.account.WithRLock(func() {
-		c.xdbread(func(tx *bstore.Tx) {
-			mb = c.xmailbox(tx, name, "")
+	// UIDs are kept in memory by the account, so we don't have to read all messages
+	// of large mailboxes. Only when they are not available, we read them with the
+	// write lock held.
+	xselect := func(withLock func(fn func()), fill bool) (ok bool) {
+		withLock(func() {
+			c.xdbread(func(tx *bstore.Tx) {
+				mb = c.xmailbox(tx, name, "")
 
-			q := bstore.QueryTx[store.Message](tx)
-			q.FilterNonzero(store.Message{MailboxID: mb.ID})
-			q.FilterEqual("Expunged", false)
-			q.SortAsc("UID")
-			c.uids = []store.UID{}
-			var seq msgseq = 1
-			err := q.ForEach(func(m store.Message) error {
-				c.uids = append(c.uids, m.UID)
-				if firstUnseen == 0 && !m.Seen {
-					firstUnseen = seq
+				var err error
+				c.uids, ok, err = c.account.MailboxUIDs(tx, mb, fill)
+				xcheckf(err, "fetching uids")
+				if !ok {
+					return
+				}
+				if sanityChecks {
+					checkUIDs(c.uids)
 				}
-				seq++
-				return nil
-			})
-			if sanityChecks {
-				checkUIDs(c.uids)
-			}
-			xcheckf(err, "fetching uids")
 
-			// Condstore extension, find the highest modseq.
-			if c.enabled[capCondstore] {
-				highestModSeq = c.xhighestModSeq(tx, mb.ID)
-			}
-			// For QRESYNC, we need to know the highest modset of deleted expunged records to
-			// maintain synchronization.
-			if c.enabled[capQresync] {
-				highDeletedModSeq, err = c.account.HighestDeletedModSeq(tx)
-				xcheckf(err, "getting highest deleted modseq")
-			}
+				// Only IMAP4rev1 clients get the first unseen message.
+				if !c.enabled[capIMAP4rev2] && mb.Unseen > 0 {
+					q := bstore.QueryTx[store.Message](tx)
+					q.FilterNonzero(store.Message{MailboxID: mb.ID})
+					q.FilterEqual("Expunged", false)
+					q.FilterEqual("Seen", false)
+					q.SortAsc("UID")
+					q.Limit(1)
+					m, err := q.Get()
+					if err != bstore.ErrAbsent {
+						xcheckf(err, "fetching first unseen message")
+						if i, found := slices.BinarySearch(c.uids, m.UID); found {
+							firstUnseen = msgseq(i + 1)
+						}
+					}
+				}
+
+				// Condstore extension, find the highest modseq.
+				if c.enabled[capCondstore] {
+					highestModSeq = c.xhighestModSeq(tx, mb.ID)
+				}
+				// For QRESYNC, we need to know the highest modset of deleted expunged records to
+				// maintain synchronization.
+				if c.enabled[capQresync] {
+					highDeletedModSeq, err = c.account.HighestDeletedModSeq(tx)
+					xcheckf(err, "getting highest deleted modseq")
+				}
+			})
 		})
-	})
+		return ok
+	}
+	if !xselect(c.account.WithRLock, false) {
+		xselect(c.account.WithWLock, true)
+	}
 	c.applyChanges(c.comm.Get(), true)
 
 	var flags string
@@ -2653,19 +2883,19 @@ func (c *conn) xstatusLine(tx *bstore.Tx, mb store.Mailbox, attrs []string) stri
 			status = append(status, A, "0")
 		case "APPENDLIMIT":
 			// ../rfc/7889:255
-			status = append(status, A, "NIL")
+			if c.maxMessageSize > 0 {
+				status = append(status, A, fmt.Sprintf("%d", c.maxMessageSize))
+			} else {
+				status = append(status, A, "NIL")
+			}
 		case "HIGHESTMODSEQ":
 			// ../rfc/7162:366
 			status = append(status, A, fmt.Sprintf("%d", c.xhighestModSeq(tx, mb.ID).Client()))
 		case "DELETED-STORAGE":
 			// ../rfc/9208:394
 			// How much storage space could be reclaimed by expunging messages with the
-			// \Deleted flag. We could keep track of this number and return it efficiently.
-			// Calculating it each time can be slow, and we don't know if clients request it.
-			// Clients are not likely to set the deleted flag without immediately expunging
-			// nowadays. Let's wait for something to need it to go through the trouble, and
-			// always return 0 for now.
-			status = append(status, A, "0")
+			// \Deleted flag. Kept up to date in the mailbox counts.
+			status = append(status, A, fmt.Sprintf("%d", mb.DeletedSize))
 		default:
 			xsyntaxErrorf("unknown attribute %q", a)
 		}
@@ -2731,11 +2961,29 @@ func (c *conn) cmdAppend(tag, cmd string, p *parser) {
 	utf8 := p.take("UTF8 (")
 	size, sync := p.xliteralSize(0, utf8)
 
-	name = xcheckmailboxname(name, true)
-	c.xdbread(func(tx *bstore.Tx) {
-		c.xmailbox(tx, name, "TRYCREATE")
-	})
+	// Check the mailbox, message size and quota. For synchronizing literals we do this
+	// before the client sends the message data, so large messages that won't be
+	// accepted are never transferred. For non-synchronizing literals, the client is
+	// already sending the data, so we can only check after reading it.
+	xcheckAppend := func() {
+		name = xcheckmailboxname(name, true)
+		if c.maxMessageSize > 0 && size > c.maxMessageSize {
+			// ../rfc/7889:143
+			xusercodeErrorf("TOOBIG", "message of %d bytes larger than maximum size %d", size, c.maxMessageSize)
+		}
+		c.xdbread(func(tx *bstore.Tx) {
+			c.xmailbox(tx, name, "TRYCREATE")
+
+			ok, maxSize, err := c.account.CanAddMessageSize(tx, size)
+			xcheckf(err, "checking quota")
+			if !ok {
+				// ../rfc/9051:5155 ../rfc/9208:472
+				xusercodeErrorf("OVERQUOTA", "account over maximum total message size %d", maxSize)
+			}
+		})
+	}
 	if sync {
+		xcheckAppend()
 		c.writelinef("+ ")
 	}
 
@@ -2773,7 +3021,7 @@ func (c *conn) cmdAppend(tag, cmd string, p *parser) {
 	}
 	p.xempty()
 	if !sync {
-		name = xcheckmailboxname(name, true)
+		xcheckAppend()
 	}
 
 	var mb store.Mailbox
@@ -3397,6 +3645,7 @@ func (c *conn) cmdxCopy(isUID bool, tag, cmd string, p *parser) {
 					m.IsReject = false
 				}
 				m.TrainedJunk = nil
+				m.TrainedShared = false
 				m.JunkFlagsForMailbox(mbDst, conf)
 				err := tx.Insert(&m)
 				xcheckf(err, "inserting message")
@@ -3450,7 +3699,7 @@ func (c *conn) cmdxCopy(isUID bool, tag, cmd string, p *parser) {
 				xcheckf(err, "sync directory")
 			}
 
-			err = c.account.RetrainMessages(context.TODO(), c.log, tx, nmsgs, false)
+			err = c.account.RetrainFlagChanges(context.TODO(), c.log, tx, nmsgs)
 			xcheckf(err, "train copied messages")
 		})
 
@@ -3594,7 +3843,7 @@ func (c *conn) cmdxMove(isUID bool, tag, cmd string, p *parser) {
 			err = tx.Update(&mbDst)
 			xcheckf(err, "updating destination mailbox for uids, keywords and counts")
 
-			err = c.account.RetrainMessages(context.TODO(), c.log, tx, msgs, false)
+			err = c.account.RetrainFlagChanges(context.TODO(), c.log, tx, msgs)
 			xcheckf(err, "retraining messages after move")
 
 			// Prepare broadcast changes to other connections.
@@ -3813,7 +4062,7 @@ func (c *conn) cmdxStore(isUID bool, tag, cmd string, p *parser) {
 				changes = append(changes, mb.ChangeKeywords())
 			}
 
-			err = c.account.RetrainMessages(context.TODO(), c.log, tx, updated, false)
+			err = c.account.RetrainFlagChanges(context.TODO(), c.log, tx, updated)
 			xcheckf(err, "training messages")
 		})
 
@@ -138,7 +138,7 @@ Content-Transfer-Encoding: Quoted-printable
 --unique-boundary-1--
 `)
 
-func tcheck(t *testing.T, err error, msg string) {
+func tcheck(t testing.TB, err error, msg string) {
 	t.Helper()
 	if err != nil {
 		t.Fatalf("%s: %s", msg, err)
@@ -156,7 +156,7 @@ func mockUIDValidity() func() {
 }
 
 type testconn struct {
-	t          *testing.T
+	t          testing.TB
 	conn       net.Conn
 	client     *imapclient.Conn
 	done       chan struct{}
@@ -233,7 +233,7 @@ next:
 	}
 }
 
-func tuntagged(t *testing.T, got imapclient.Untagged, dst any) {
+func tuntagged(t testing.TB, got imapclient.Untagged, dst any) {
 	t.Helper()
 	gotv := reflect.ValueOf(got)
 	dstv := reflect.ValueOf(dst)
@@ -327,18 +327,29 @@ func xparseNumSet(s string) imapclient.NumSet {
 
 var connCounter int64
 
-func start(t *testing.T) *testconn {
+func start(t testing.TB) *testconn {
 	return startArgs(t, true, false, true, true, "mjl")
 }
 
-func startNoSwitchboard(t *testing.T) *testconn {
+func startNoSwitchboard(t testing.TB) *testconn {
 	return startArgs(t, false, false, true, false, "mjl")
 }
 
 const password0 = "te\u0301st \u00a0\u2002\u200a" // NFD and various unicode spaces.
 const password1 = "tést    "                      // PRECIS normalized, with NFC.
 
-func startArgs(t *testing.T, first, isTLS, allowLoginWithoutTLS, setPassword bool, accname string) *testconn {
+func startArgs(t testing.TB, first, isTLS, allowLoginWithoutTLS, setPassword bool, accname string) *testconn {
+	return startArgsMore(t, first, isTLS, allowLoginWithoutTLS, false, setPassword, accname, 0)
+}
+
+func startArgsMore(t testing.TB, first, isTLS, allowLoginWithoutTLS, noPlaintextAuth, setPassword bool, accname string, maxMessageSize int64) *testconn {
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{fakeCert(t)},
+	}
+	return startArgsTLS(t, first, isTLS, allowLoginWithoutTLS, noPlaintextAuth, setPassword, accname, maxMessageSize, tlsConfig, &tls.Config{InsecureSkipVerify: true})
+}
+
+func startArgsTLS(t testing.TB, first, isTLS, allowLoginWithoutTLS, noPlaintextAuth, setPassword bool, accname string, maxMessageSize int64, tlsConfig, clientTLSConfig *tls.Config) *testconn {
 	limitersInit() // Reset rate limiters.
 
 	if first {
@@ -360,19 +371,16 @@ func startArgs(t *testing.T, first, isTLS, allowLoginWithoutTLS, setPassword boo
 
 	serverConn, clientConn := net.Pipe()
 
-	tlsConfig := &tls.Config{
-		Certificates: []tls.Certificate{fakeCert(t)},
-	}
 	if isTLS {
 		serverConn = tls.Server(serverConn, tlsConfig)
-		clientConn = tls.Client(clientConn, &tls.Config{InsecureSkipVerify: true})
+		clientConn = tls.Client(clientConn, clientTLSConfig)
 	}
 
 	done := make(chan struct{})
 	connCounter++
 	cid := connCounter
 	go func() {
-		serve("test", cid, tlsConfig, serverConn, isTLS, allowLoginWithoutTLS)
+		serve("test", cid, tlsConfig, serverConn, isTLS, allowLoginWithoutTLS, noPlaintextAuth, maxMessageSize)
 		switchStop()
 		close(done)
 	}()
@@ -381,7 +389,7 @@ func startArgs(t *testing.T, first, isTLS, allowLoginWithoutTLS, setPassword boo
 	return &testconn{t: t, conn: clientConn, client: client, done: done, serverConn: serverConn, account: acc}
 }
 
-func fakeCert(t *testing.T) tls.Certificate {
+func fakeCert(t testing.TB) tls.Certificate {
 	privKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize)) // Fake key, don't use this for real!
 	template := &x509.Certificate{
 		SerialNumber: big.NewInt(1), // Required field...
@@ -646,6 +654,30 @@ func TestID(t *testing.T) {
 	tc.transactf("bad", `id ("name" "mox" "name" "mox")`) // Duplicate field.
 }
 
+func TestProtocolSession(t *testing.T) {
+	tc := start(t)
+	defer tc.close()
+	tc.client.Login("mjl@mox.example", password0)
+	tc.transactf("ok", `id ("name" "testclient" "version" "1.0")`)
+
+	l, err := tc.account.ProtocolSessions(ctxbg)
+	tcheck(t, err, "list sessions")
+	if len(l) != 1 || !l[0].Active || l[0].Protocol != "imap" || l[0].LoginAddress != "mjl@mox.example" || l[0].ClientID != "testclient 1.0" {
+		t.Fatalf("got sessions %#v, expected single active imap session", l)
+	}
+
+	// Closing the session closes the connection.
+	_, err = tc.account.ProtocolSessionClose(pkglog, l[0].ID)
+	tcheck(t, err, "close session")
+	tc.waitDone()
+
+	l, err = tc.account.ProtocolSessions(ctxbg)
+	tcheck(t, err, "list sessions")
+	if len(l) != 1 || l[0].Active || !l[0].Closed || l[0].Ended.IsZero() {
+		t.Fatalf("got sessions %#v, expected single closed session", l)
+	}
+}
+
 func TestSequence(t *testing.T) {
 	tc := start(t)
 	defer tc.close()
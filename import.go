@@ -7,30 +7,34 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"log/slog"
 	"net"
 	"os"
+	"path"
 	"path/filepath"
 	"runtime/debug"
 	"strings"
 	"time"
 
 	"golang.org/x/exp/maps"
+	"golang.org/x/text/unicode/norm"
 
 	"github.com/mjl-/mox/config"
 	"github.com/mjl-/mox/message"
 	"github.com/mjl-/mox/metrics"
 	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/pst"
 	"github.com/mjl-/mox/store"
 )
 
 // todo: add option to trust imported messages, causing us to look at Authentication-Results and Received-SPF headers and add eg verified spf/dkim/dmarc domains to our store, to jumpstart reputation.
 
-const importCommonHelp = `The mbox/maildir archive is accessed and imported by the running mox process, so
-it must have access to the archive files. The default suggested systemd service
-file isolates mox from most of the file system, with only the "data/" directory
-accessible, so you may want to put the mbox/maildir archive files in a
+const importCommonHelp = `The mbox/maildir/eml/pst archive is accessed and imported by the running mox
+process, so it must have access to the archive files. The default suggested
+systemd service file isolates mox from most of the file system, with only the
+"data/" directory accessible, so you may want to put the archive files in a
 directory like "data/import/" to make it available to mox.
 
 By default, messages will train the junk filter based on their flags and, if
@@ -41,7 +45,8 @@ are added to the message metadata, causing later incoming messages from these
 recipients to be accepted, unless other reputation signals prevent that.
 
 Users can also import mailboxes/messages through the account web page by
-uploading a zip or tgz file with mbox and/or maildirs.
+uploading a zip or tgz file with mbox files, maildirs and/or .eml files, or a
+pst file.
 `
 
 func cmdImportMaildir(c *cmd) {
@@ -57,7 +62,7 @@ dovecot-keywords file can specify additional flags, like Forwarded/Junk/NotJunk.
 		c.Usage()
 	}
 	mustLoadConfig()
-	ctlcmdImport(xctl(), false, args[0], args[1], args[2])
+	ctlcmdImport(xctl(), "maildir", args[0], args[1], args[2])
 }
 
 func cmdImportMbox(c *cmd) {
@@ -72,7 +77,51 @@ Using mbox is not recommended, maildir is a better defined format.
 		c.Usage()
 	}
 	mustLoadConfig()
-	ctlcmdImport(xctl(), true, args[0], args[1], args[2])
+	ctlcmdImport(xctl(), "mbox", args[0], args[1], args[2])
+}
+
+func cmdImportEML(c *cmd) {
+	c.params = "accountname mailboxname dir"
+	c.help = `Import a directory tree with .eml files into an account.
+
+` + importCommonHelp + `
+The .eml files in the directory are imported into the mailbox. Subdirectories
+are imported as child mailboxes. If a directory has a metadata.json file, as
+written by "mox export eml", it is used to set the flags, keywords and received
+time of messages. Otherwise the received time is taken from the Date header.
+`
+	args := c.Parse()
+	if len(args) != 3 {
+		c.Usage()
+	}
+	mustLoadConfig()
+	ctlcmdImport(xctl(), "eml", args[0], args[1], args[2])
+}
+
+func cmdImportPST(c *cmd) {
+	c.params = "[-prefix mailbox] accountname file.pst"
+	c.help = `Import the mail folders of an Outlook PST file into an account.
+
+` + importCommonHelp + `
+Mail folders are imported as mailboxes with the same names and hierarchy,
+optionally as child mailboxes of the mailbox specified with -prefix. Folders
+with contacts, calendar items, tasks and notes are skipped. Messages are
+converted to regular internet messages, keeping the original headers for
+received messages. The read, replied, forwarded and flagged status of messages
+are imported as flags.
+
+Both ANSI (Outlook 97-2002) and Unicode (Outlook 2003 and later) PST files are
+supported, without encryption or with the default "compressible encryption".
+PST files with "high encryption" cannot be imported.
+`
+	var prefix string
+	c.flag.StringVar(&prefix, "prefix", "", "import folders as child mailboxes of this mailbox")
+	args := c.Parse()
+	if len(args) != 2 {
+		c.Usage()
+	}
+	mustLoadConfig()
+	ctlcmdImport(xctl(), "pst", args[0], strings.Trim(prefix, "/"), args[1])
 }
 
 func cmdXImportMaildir(c *cmd) {
@@ -83,7 +132,7 @@ func cmdXImportMaildir(c *cmd) {
 
 See "mox help import maildir" for details.
 `
-	xcmdXImport(false, c)
+	xcmdXImport("maildir", c)
 }
 
 func cmdXImportMbox(c *cmd) {
@@ -93,10 +142,10 @@ func cmdXImportMbox(c *cmd) {
 
 See "mox help import mbox" for details.
 `
-	xcmdXImport(true, c)
+	xcmdXImport("mbox", c)
 }
 
-func xcmdXImport(mbox bool, c *cmd) {
+func xcmdXImport(kind string, c *cmd) {
 	args := c.Parse()
 	if len(args) != 3 {
 		c.Usage()
@@ -124,15 +173,13 @@ func xcmdXImport(mbox bool, c *cmd) {
 	serverctl := ctl{conn: sconn, r: bufio.NewReader(sconn), log: c.log}
 	go servectlcmd(context.Background(), &serverctl, func() {})
 
-	ctlcmdImport(&clientctl, mbox, account, args[1], args[2])
+	ctlcmdImport(&clientctl, kind, account, args[1], args[2])
 }
 
-func ctlcmdImport(ctl *ctl, mbox bool, account, mailbox, src string) {
-	if mbox {
-		ctl.xwrite("importmbox")
-	} else {
-		ctl.xwrite("importmaildir")
-	}
+// ctlcmdImport imports from src, with kind "maildir", "mbox", "eml" or "pst". For
+// pst, mailbox is the prefix for the mailboxes, and can be empty.
+func ctlcmdImport(ctl *ctl, kind, account, mailbox, src string) {
+	ctl.xwrite("import" + kind)
 	ctl.xwrite(account)
 	if strings.EqualFold(mailbox, "Inbox") {
 		mailbox = "Inbox"
@@ -157,12 +204,12 @@ func ctlcmdImport(ctl *ctl, mbox bool, account, mailbox, src string) {
 	fmt.Fprintf(os.Stderr, "%s imported\n", count)
 }
 
-func importctl(ctx context.Context, ctl *ctl, mbox bool) {
+func importctl(ctx context.Context, ctl *ctl, kind string) {
 	/* protocol:
-	> "importmaildir" or "importmbox"
+	> "importmaildir", "importmbox", "importeml" or "importpst"
 	> account
-	> mailbox
-	> src (mbox file or maildir directory)
+	> mailbox (for pst, the mailbox to import folders under, can be empty)
+	> src (mbox file, maildir directory, directory tree with eml files, or pst file)
 	< "ok" or error
 	< "progress" count (zero or more times, once for every 1000 messages)
 	< "ok" when done, or error
@@ -172,10 +219,6 @@ func importctl(ctx context.Context, ctl *ctl, mbox bool) {
 	mailbox := ctl.xread()
 	src := ctl.xread()
 
-	kind := "maildir"
-	if mbox {
-		kind = "mbox"
-	}
 	ctl.log.Info("importing messages",
 		slog.String("kind", kind),
 		slog.String("account", account),
@@ -183,9 +226,16 @@ func importctl(ctx context.Context, ctl *ctl, mbox bool) {
 		slog.String("source", src))
 
 	var err error
-	var mboxf *os.File
+	var mboxf, pstf *os.File
 	var mdnewf, mdcurf *os.File
-	var msgreader store.MsgSource
+
+	// Each source of messages is imported into a mailbox. Maildirs and mbox files
+	// have a single source, eml directory trees and pst files can have many.
+	type importSource struct {
+		mailbox   string
+		msgreader store.MsgSource
+	}
+	var sources []importSource
 
 	// Open account, creating a database file if it doesn't exist yet. It must be known
 	// in the configuration file.
@@ -214,24 +264,74 @@ func importctl(ctx context.Context, ctl *ctl, mbox bool) {
 			err := mdcurf.Close()
 			ctl.log.Check(err, "closing maildir cur after import")
 		}
+		if pstf != nil {
+			err := pstf.Close()
+			ctl.log.Check(err, "closing pst file after import")
+		}
 	}()
 
 	// Messages don't always have a junk flag set. We'll assume anything in a mailbox
 	// starting with junk or spam is junk mail.
 
-	// First check if we can access the mbox/maildir.
+	// First check if we can access the mbox/maildir/eml/pst files.
 	// Mox needs to be able to access those files, the user running the import command
 	// may be a different user who can access the files.
-	if mbox {
+	switch kind {
+	case "mbox":
 		mboxf, err = os.Open(src)
 		ctl.xcheck(err, "open mbox file")
-		msgreader = store.NewMboxReader(ctl.log, store.CreateMessageTemp, src, mboxf)
-	} else {
+		sources = append(sources, importSource{mailbox, store.NewMboxReader(ctl.log, store.CreateMessageTemp, src, mboxf)})
+	case "maildir":
 		mdnewf, err = os.Open(filepath.Join(src, "new"))
 		ctl.xcheck(err, "open subdir new of maildir")
 		mdcurf, err = os.Open(filepath.Join(src, "cur"))
 		ctl.xcheck(err, "open subdir cur of maildir")
-		msgreader = store.NewMaildirReader(ctl.log, store.CreateMessageTemp, mdnewf, mdcurf)
+		sources = append(sources, importSource{mailbox, store.NewMaildirReader(ctl.log, store.CreateMessageTemp, mdnewf, mdcurf)})
+	case "eml":
+		// Subdirectories are imported into child mailboxes.
+		err = filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || !d.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(src, p)
+			if err != nil {
+				return err
+			}
+			name := mailbox
+			if rel != "." {
+				name = path.Join(mailbox, norm.NFC.String(filepath.ToSlash(rel)))
+			}
+			mr, err := store.NewEMLReader(ctl.log, store.CreateMessageTemp, p)
+			if err != nil {
+				return err
+			}
+			sources = append(sources, importSource{name, mr})
+			return nil
+		})
+		ctl.xcheck(err, "reading eml directories")
+	case "pst":
+		pstf, err = os.Open(src)
+		ctl.xcheck(err, "open pst file")
+		pf, err := pst.Open(pstf)
+		ctl.xcheck(err, "reading pst file")
+		folders, err := pf.Folders()
+		ctl.xcheck(err, "reading folders from pst file")
+		for _, folder := range folders {
+			var elems []string
+			if mailbox != "" {
+				elems = append(elems, mailbox)
+			}
+			for _, e := range folder.Path {
+				elems = append(elems, strings.ReplaceAll(norm.NFC.String(e), "/", "-"))
+			}
+			name, _, err := store.CheckMailboxName(strings.Join(elems, "/"), true)
+			ctl.xcheck(err, "checking mailbox name for pst folder")
+			mr, err := store.NewPSTReader(ctl.log, store.CreateMessageTemp, pf, folder)
+			ctl.xcheck(err, "reading messages of pst folder")
+			sources = append(sources, importSource{name, mr})
+		}
+	default:
+		ctl.xcheck(fmt.Errorf("unknown import kind %q", kind), "import")
 	}
 
 	tx, err := a.DB.Begin(ctx, true)
@@ -293,14 +393,6 @@ func importctl(ctx context.Context, ctl *ctl, mbox bool) {
 	// todo: one goroutine for reading messages, one for parsing the message, one adding to database, one for junk filter training.
 	n := 0
 	a.WithWLock(func() {
-		// Ensure mailbox exists.
-		var mb store.Mailbox
-		mb, changes, err = a.MailboxEnsure(tx, mailbox, true)
-		ctl.xcheck(err, "ensuring mailbox exists")
-
-		// We ensure keywords in messages make it to the mailbox as well.
-		mailboxKeywords := map[string]bool{}
-
 		jf, _, err := a.OpenJunkFilter(ctx, ctl.log)
 		if err != nil && !errors.Is(err, store.ErrNoJunkFilter) {
 			ctl.xcheck(err, "open junk filter")
@@ -320,80 +412,111 @@ func importctl(ctx context.Context, ctl *ctl, mbox bool) {
 		err = tx.Get(&du)
 		ctl.xcheck(err, "get disk usage")
 
-		process := func(m *store.Message, msgf *os.File, origPath string) {
-			defer store.CloseRemoveTempFile(ctl.log, msgf, "message to import")
+		importMailbox := func(mailbox string, msgreader store.MsgSource) {
+			// Ensure mailbox exists.
+			mb, mbchanges, err := a.MailboxEnsure(tx, mailbox, true)
+			ctl.xcheck(err, "ensuring mailbox exists")
+			changes = append(changes, mbchanges...)
 
-			addSize += m.Size
-			if maxSize > 0 && du.MessageSize+addSize > maxSize {
-				ctl.xcheck(fmt.Errorf("account over maximum total message size %d", maxSize), "checking quota")
-			}
+			// We ensure keywords in messages make it to the mailbox as well.
+			mailboxKeywords := map[string]bool{}
 
-			for _, kw := range m.Keywords {
-				mailboxKeywords[kw] = true
-			}
-			mb.Add(m.MailboxCounts())
+			process := func(m *store.Message, msgf *os.File, origPath string) {
+				defer store.CloseRemoveTempFile(ctl.log, msgf, "message to import")
 
-			// Parse message and store parsed information for later fast retrieval.
-			p, err := message.EnsurePart(ctl.log.Logger, false, msgf, m.Size)
-			if err != nil {
-				ctl.log.Infox("parsing message, continuing", err, slog.String("path", origPath))
-			}
-			m.ParsedBuf, err = json.Marshal(p)
-			ctl.xcheck(err, "marshal parsed message structure")
-
-			// Set fields needed for future threading. By doing it now, DeliverMessage won't
-			// have to parse the Part again.
-			p.SetReaderAt(store.FileMsgReader(m.MsgPrefix, msgf))
-			m.PrepareThreading(ctl.log, &p)
-
-			if m.Received.IsZero() {
-				if p.Envelope != nil && !p.Envelope.Date.IsZero() {
-					m.Received = p.Envelope.Date
-				} else {
-					m.Received = time.Now()
+				addSize += m.Size
+				if maxSize > 0 && du.MessageSize+addSize > maxSize {
+					ctl.xcheck(fmt.Errorf("account over maximum total message size %d", maxSize), "checking quota")
 				}
-			}
 
-			// We set the flags that Deliver would set now and train ourselves. This prevents
-			// Deliver from training, which would open the junk filter, change it, and write it
-			// back to disk, for each message (slow).
-			m.JunkFlagsForMailbox(mb, conf)
-			if jf != nil && m.NeedsTraining() {
-				if words, err := jf.ParseMessage(p); err != nil {
-					ctl.log.Infox("parsing message for updating junk filter", err, slog.String("parse", ""), slog.String("path", origPath))
-				} else {
-					err = jf.Train(ctx, !m.Junk, words)
-					ctl.xcheck(err, "training junk filter")
-					m.TrainedJunk = &m.Junk
+				for _, kw := range m.Keywords {
+					mailboxKeywords[kw] = true
+				}
+				mb.Add(m.MailboxCounts())
+
+				// Parse message and store parsed information for later fast retrieval.
+				p, err := message.EnsurePart(ctl.log.Logger, false, msgf, m.Size)
+				if err != nil {
+					ctl.log.Infox("parsing message, continuing", err, slog.String("path", origPath))
+				}
+				m.Sealed.ParsedBuf, err = json.Marshal(p)
+				ctl.xcheck(err, "marshal parsed message structure")
+
+				// Set fields needed for future threading. By doing it now, DeliverMessage won't
+				// have to parse the Part again.
+				p.SetReaderAt(store.FileMsgReader(m.Sealed.MsgPrefix, msgf))
+				m.PrepareThreading(ctl.log, a.Name, &p)
+
+				if m.Received.IsZero() {
+					if p.Envelope != nil && !p.Envelope.Date.IsZero() {
+						m.Received = p.Envelope.Date
+					} else {
+						m.Received = time.Now()
+					}
+				}
+
+				// We set the flags that Deliver would set now and train ourselves. This prevents
+				// Deliver from training, which would open the junk filter, change it, and write it
+				// back to disk, for each message (slow).
+				m.JunkFlagsForMailbox(mb, conf)
+				if jf != nil && m.NeedsTraining() {
+					if words, err := jf.ParseMessage(p); err != nil {
+						ctl.log.Infox("parsing message for updating junk filter", err, slog.String("parse", ""), slog.String("path", origPath))
+					} else {
+						err = jf.Train(ctx, !m.Junk, words)
+						ctl.xcheck(err, "training junk filter")
+						m.TrainedJunk = &m.Junk
+					}
 				}
-			}
 
-			if modseq == 0 {
-				var err error
-				modseq, err = a.NextModSeq(tx)
-				ctl.xcheck(err, "assigning next modseq")
+				if modseq == 0 {
+					var err error
+					modseq, err = a.NextModSeq(tx)
+					ctl.xcheck(err, "assigning next modseq")
+				}
+
+				m.MailboxID = mb.ID
+				m.MailboxOrigID = mb.ID
+				m.CreateSeq = modseq
+				m.ModSeq = modseq
+				xdeliver(m, msgf)
+
+				n++
+				if n%1000 == 0 {
+					ctl.xwrite(fmt.Sprintf("progress %d", n))
+				}
 			}
 
-			m.MailboxID = mb.ID
-			m.MailboxOrigID = mb.ID
-			m.CreateSeq = modseq
-			m.ModSeq = modseq
-			xdeliver(m, msgf)
+			for {
+				m, msgf, origPath, err := msgreader.Next()
+				if err == io.EOF {
+					break
+				}
+				ctl.xcheck(err, "reading next message")
 
-			n++
-			if n%1000 == 0 {
-				ctl.xwrite(fmt.Sprintf("progress %d", n))
+				process(m, msgf, origPath)
 			}
-		}
 
-		for {
-			m, msgf, origPath, err := msgreader.Next()
-			if err == io.EOF {
-				break
+			// Get mailbox again, uidnext is likely updated.
+			mc := mb.MailboxCounts
+			err = tx.Get(&mb)
+			ctl.xcheck(err, "get mailbox")
+			mb.MailboxCounts = mc
+
+			// If there are any new keywords, update the mailbox.
+			var mbKwChanged bool
+			mb.Keywords, mbKwChanged = store.MergeKeywords(mb.Keywords, maps.Keys(mailboxKeywords))
+			if mbKwChanged {
+				changes = append(changes, mb.ChangeKeywords())
 			}
-			ctl.xcheck(err, "reading next message")
 
-			process(m, msgf, origPath)
+			err = tx.Update(&mb)
+			ctl.xcheck(err, "updating message counts and keywords in mailbox")
+			changes = append(changes, mb.ChangeCounts())
+		}
+
+		for _, src := range sources {
+			importMailbox(src.mailbox, src.msgreader)
 		}
 
 		// Match threads.
@@ -402,23 +525,6 @@ func importctl(ctx context.Context, ctl *ctl, mbox bool) {
 			ctl.xcheck(err, "assigning messages to threads")
 		}
 
-		// Get mailbox again, uidnext is likely updated.
-		mc := mb.MailboxCounts
-		err = tx.Get(&mb)
-		ctl.xcheck(err, "get mailbox")
-		mb.MailboxCounts = mc
-
-		// If there are any new keywords, update the mailbox.
-		var mbKwChanged bool
-		mb.Keywords, mbKwChanged = store.MergeKeywords(mb.Keywords, maps.Keys(mailboxKeywords))
-		if mbKwChanged {
-			changes = append(changes, mb.ChangeKeywords())
-		}
-
-		err = tx.Update(&mb)
-		ctl.xcheck(err, "updating message counts and keywords in mailbox")
-		changes = append(changes, mb.ChangeCounts())
-
 		err = a.AddMessageSize(ctl.log, tx, addSize)
 		xcheckf(err, "updating total message size")
 
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/mjl-/mox/imapmigrate"
+	"github.com/mjl-/mox/store"
+)
+
+func cmdImportIMAP(c *cmd) {
+	c.params = "[-starttls] [-prefix mailbox] accountname host[:port] username"
+	c.help = `Migrate mailboxes and messages from an account at a remote IMAP server.
+
+All mailboxes of the remote account, with their hierarchy, messages, flags,
+keywords and receive times, are copied into the local account. The remote
+account is not modified: mailboxes are only examined and messages are fetched
+without setting the seen flag. The password for the remote account is read from
+stdin.
+
+The progress is stored in the account database for each remote mailbox. If a
+migration is interrupted, running the command again continues where it left
+off. Running the command again after a completed migration adds new messages
+from the remote server, and updates the flags of earlier migrated messages to
+those at the remote server. This can be repeated until the MX records are
+switched to mox and clients are reconfigured. Messages that are removed at the
+remote server after they were migrated are not removed locally.
+
+Without -prefix, special-use mailboxes, such as Sent, Trash and Junk, are
+migrated into the local mailboxes with the same special-use. Virtual mailboxes
+with special-use \All, \Flagged and \Important, as offered by Gmail, are
+skipped.
+
+The remote server is connected to with TLS on port 993, or with -starttls on port
+143. The TLS certificate of the server must be valid for the host name.
+
+Users can also migrate from a remote IMAP server through the account web page.
+`
+	var startTLS bool
+	var prefix string
+	c.flag.BoolVar(&startTLS, "starttls", false, "connect without tls, then use starttls")
+	c.flag.StringVar(&prefix, "prefix", "", "migrate remote mailboxes as child mailboxes of this local mailbox")
+	args := c.Parse()
+	if len(args) != 3 {
+		c.Usage()
+	}
+	mustLoadConfig()
+
+	tlsMode := imapmigrate.TLSImmediate
+	if startTLS {
+		tlsMode = imapmigrate.TLSStartTLS
+	}
+
+	fmt.Fprintf(os.Stderr, "password for %s at %s: ", args[2], args[1])
+	pw, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	xcheckf(err, "reading password from stdin")
+	pw = strings.TrimSuffix(strings.TrimSuffix(pw, "\n"), "\r")
+
+	ctlcmdImportIMAP(xctl(), args[0], args[1], string(tlsMode), args[2], pw, prefix)
+}
+
+func ctlcmdImportIMAP(ctl *ctl, account, host, tlsMode, username, password, prefix string) {
+	ctl.xwrite("importimap")
+	ctl.xwrite(account)
+	ctl.xwrite(host)
+	ctl.xwrite(tlsMode)
+	ctl.xwrite(username)
+	ctl.xwrite(password)
+	ctl.xwrite(prefix)
+	ctl.xreadok()
+	fmt.Fprintln(os.Stderr, "migrating...")
+	for {
+		line := ctl.xread()
+		if s, ok := strings.CutPrefix(line, "progress "); ok {
+			fmt.Fprintf(os.Stderr, "%s...\n", s)
+			continue
+		} else if s, ok := strings.CutPrefix(line, "problem "); ok {
+			fmt.Fprintf(os.Stderr, "problem: %s\n", s)
+			continue
+		}
+		if line != "ok" {
+			log.Fatalf("migration, expected ok, got %q", line)
+		}
+		break
+	}
+	mailboxes := ctl.xread()
+	added := ctl.xread()
+	skipped := ctl.xread()
+	flags := ctl.xread()
+	fmt.Fprintf(os.Stderr, "%s mailboxes migrated, %s messages added, %s already present, %s with updated flags\n", mailboxes, added, skipped, flags)
+}
+
+func importimapctl(ctx context.Context, ctl *ctl) {
+	/* protocol:
+	> "importimap"
+	> account
+	> host, with optional port
+	> tls mode, "tls" or "starttls"
+	> username
+	> password
+	> prefix, or empty
+	< "ok" or error
+	< "progress" mailbox: count, or "problem" text (zero or more times)
+	< "ok" when done, or error
+	< count of migrated mailboxes
+	< count of added messages
+	< count of skipped messages, already present
+	< count of messages with updated flags
+	*/
+	account := ctl.xread()
+	remote := imapmigrate.Remote{
+		Host:     ctl.xread(),
+		TLSMode:  imapmigrate.TLSMode(ctl.xread()),
+		Username: ctl.xread(),
+		Password: ctl.xread(),
+	}
+	prefix := strings.Trim(ctl.xread(), "/")
+
+	ctl.log.Info("migrating from remote imap server",
+		slog.String("account", account),
+		slog.String("host", remote.Host),
+		slog.String("username", remote.Username),
+		slog.String("prefix", prefix))
+
+	acc, err := store.OpenAccount(ctl.log, account)
+	ctl.xcheck(err, "opening account")
+	defer func() {
+		err := acc.Close()
+		ctl.log.Check(err, "closing account after migration")
+	}()
+	ctl.xwriteok()
+
+	opts := imapmigrate.Options{
+		Prefix: prefix,
+		Progress: func(mailbox string, count int) {
+			ctl.xwrite(fmt.Sprintf("progress %s: %d", mailbox, count))
+		},
+		Problem: func(msg string) {
+			ctl.xwrite("problem " + msg)
+		},
+	}
+	result, err := imapmigrate.Migrate(ctx, ctl.log, acc, remote, opts)
+	ctl.xcheck(err, "migrating")
+	ctl.xwriteok()
+	ctl.xwrite(fmt.Sprintf("%d", result.Mailboxes))
+	ctl.xwrite(fmt.Sprintf("%d", result.Messages))
+	ctl.xwrite(fmt.Sprintf("%d", result.Skipped))
+	ctl.xwrite(fmt.Sprintf("%d", result.Flags))
+}
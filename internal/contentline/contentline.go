@@ -0,0 +1,197 @@
+// Package contentline parses and writes content lines, the line format shared
+// by iCalendar (RFC 5545) and vCard (RFC 6350): a property name, optional
+// parameters and a value, with long lines folded.
+package contentline
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+)
+
+// Param is a property parameter, e.g. PARTSTAT=ACCEPTED or TYPE=work,voice.
+type Param struct {
+	Name   string // Upper case.
+	Values []string
+}
+
+// Line is a parsed content line.
+type Line struct {
+	Group  string // Optional, before the name, separated by a dot. Only for vCard.
+	Name   string // Upper case.
+	Params []Param
+	Value  string // Raw value, still escaped. See Unescape.
+}
+
+// Unfold reads lines, joining continuation lines that start with a space or tab.
+func Unfold(r io.Reader) ([]string, error) {
+	var lines []string
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if line != "" {
+			line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+			if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+				lines[len(lines)-1] += line[1:]
+			} else {
+				lines = append(lines, line)
+			}
+		}
+		if err == io.EOF {
+			return lines, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Parse parses an unfolded line. Errors describe the syntax error, callers wrap
+// them in their own syntax error.
+//
+// If vcard is set, a group before the name is parsed, and parameters without
+// name, as in vCard 2.1, e.g. ";WORK", are parsed as TYPE parameter.
+func Parse(line string, vcard bool) (Line, error) {
+	var l Line
+
+	i := strings.IndexAny(line, ";:")
+	if i <= 0 {
+		return l, errors.New("missing property name or value")
+	}
+	name := line[:i]
+	if vcard {
+		if g, n, ok := strings.Cut(name, "."); ok {
+			l.Group = g
+			name = n
+		}
+		if name == "" {
+			return l, errors.New("empty property name")
+		}
+	}
+	l.Name = strings.ToUpper(name)
+	line = line[i:]
+
+	// Parameters, values can be quoted and can then contain ";", ":" and ",".
+	for line != "" && line[0] == ';' {
+		line = line[1:]
+		i := strings.IndexAny(line, "=;:")
+		if i < 0 || i == 0 && line[i] == '=' || !vcard && line[i] != '=' {
+			return l, errors.New("bad parameter")
+		}
+		pa := Param{Name: strings.ToUpper(line[:i])}
+		if line[i] != '=' {
+			pa = Param{Name: "TYPE", Values: []string{line[:i]}}
+			l.Params = append(l.Params, pa)
+			line = line[i:]
+			continue
+		}
+		line = line[i+1:]
+		for {
+			var v string
+			if strings.HasPrefix(line, `"`) {
+				e := strings.IndexByte(line[1:], '"')
+				if e < 0 {
+					return l, errors.New("unterminated quoted parameter value")
+				}
+				v = line[1 : 1+e]
+				line = line[2+e:]
+			} else {
+				e := strings.IndexAny(line, ",;:")
+				if e < 0 {
+					return l, errors.New("missing value")
+				}
+				v = line[:e]
+				line = line[e:]
+			}
+			pa.Values = append(pa.Values, v)
+			if line == "" || line[0] != ',' {
+				break
+			}
+			line = line[1:]
+		}
+		l.Params = append(l.Params, pa)
+	}
+	if line == "" || line[0] != ':' {
+		return l, errors.New("missing value")
+	}
+	l.Value = line[1:]
+	return l, nil
+}
+
+// Write writes the line to b, folded at 75 octets, with CRLF line ending.
+// Parameter values with special characters are quoted.
+func (l Line) Write(b *bytes.Buffer) {
+	var s strings.Builder
+	if l.Group != "" {
+		s.WriteString(l.Group + ".")
+	}
+	s.WriteString(l.Name)
+	for _, pa := range l.Params {
+		s.WriteString(";" + pa.Name + "=")
+		for i, v := range pa.Values {
+			if i > 0 {
+				s.WriteString(",")
+			}
+			if strings.ContainsAny(v, ",;:") {
+				v = `"` + v + `"`
+			}
+			s.WriteString(v)
+		}
+	}
+	s.WriteString(":" + l.Value)
+	WriteLine(b, s.String())
+}
+
+// WriteLine writes a line, folded at 75 octets without splitting UTF-8 sequences.
+func WriteLine(b *bytes.Buffer, s string) {
+	n := 75
+	for len(s) > n {
+		i := n
+		for i > 0 && s[i]&0xc0 == 0x80 {
+			i--
+		}
+		b.WriteString(s[:i] + "\r\n ")
+		s = s[i:]
+		n = 74 // Leading space counts.
+	}
+	b.WriteString(s + "\r\n")
+}
+
+// ParamValue returns the first value of the named parameter, or the empty string.
+func ParamValue(params []Param, name string) string {
+	for _, pa := range params {
+		if strings.EqualFold(pa.Name, name) && len(pa.Values) > 0 {
+			return pa.Values[0]
+		}
+	}
+	return ""
+}
+
+// Escape escapes a text value for use in a property.
+func Escape(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, "\r\n", `\n`, "\n", `\n`, ",", `\,`, ";", `\;`)
+	return r.Replace(s)
+}
+
+// Unescape returns the text value s unescaped, with \n, \, and \; resolved.
+func Unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n', 'N':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
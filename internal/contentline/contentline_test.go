@@ -0,0 +1,81 @@
+package contentline
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func tcompare(t *testing.T, got, exp any) {
+	t.Helper()
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("got:\n%#v\nexpected:\n%#v", got, exp)
+	}
+}
+
+func TestUnfoldWrite(t *testing.T) {
+	lines, err := Unfold(strings.NewReader("A:one\r\n two\r\n\tthree\nB:x"))
+	tcompare(t, err, nil)
+	tcompare(t, lines, []string{"A:onetwothree", "B:x"})
+
+	// Folding at 75 octets, without splitting UTF-8 sequences.
+	s := "NOTE:" + strings.Repeat("é", 100)
+	var b bytes.Buffer
+	WriteLine(&b, s)
+	for _, line := range strings.Split(strings.TrimSuffix(b.String(), "\r\n"), "\r\n") {
+		if len(line) > 75 {
+			t.Fatalf("line not folded: %q", line)
+		}
+	}
+	lines, err = Unfold(&b)
+	tcompare(t, err, nil)
+	tcompare(t, lines, []string{s})
+}
+
+func TestParse(t *testing.T) {
+	l, err := Parse(`attendee;cn="Doe, J";partstat=ACCEPTED,X:mailto:j@mox.example`, false)
+	tcompare(t, err, nil)
+	tcompare(t, l, Line{
+		Name: "ATTENDEE",
+		Params: []Param{
+			{Name: "CN", Values: []string{"Doe, J"}},
+			{Name: "PARTSTAT", Values: []string{"ACCEPTED", "X"}},
+		},
+		Value: "mailto:j@mox.example",
+	})
+	tcompare(t, ParamValue(l.Params, "cn"), "Doe, J")
+
+	var b bytes.Buffer
+	l.Write(&b)
+	tcompare(t, b.String(), "ATTENDEE;CN=\"Doe, J\";PARTSTAT=ACCEPTED,X:mailto:j@mox.example\r\n")
+
+	// Groups and parameters without name only for vCard.
+	l, err = Parse("item1.TEL;WORK:+1 555", true)
+	tcompare(t, err, nil)
+	tcompare(t, l, Line{Group: "item1", Name: "TEL", Params: []Param{{Name: "TYPE", Values: []string{"WORK"}}}, Value: "+1 555"})
+	_, err = Parse("TEL;WORK:+1 555", false)
+	if err == nil {
+		t.Fatalf("parameter without name accepted without vcard")
+	}
+
+	bad := []string{
+		"novalue",
+		":value",
+		"X;=a:value",
+		`X;A="a:value`,
+		"X;A=a",
+	}
+	for _, s := range bad {
+		if _, err := Parse(s, true); err == nil {
+			t.Fatalf("parsing %q: expected error", s)
+		}
+	}
+}
+
+func TestEscape(t *testing.T) {
+	s := "a; b, c\\d\r\ne"
+	tcompare(t, Escape(s), `a\; b\, c\\d\ne`)
+	tcompare(t, Unescape(Escape(s)), "a; b, c\\d\ne")
+	tcompare(t, Unescape(`a\Nb\`), "a\nb\\")
+}
@@ -222,3 +222,66 @@ const style = (x: {[k: string]: string | number}) => { return {_styles: x}}
 const prop = (x: {[k: string]: any}) => { return {_props: x}}
 return [dom, style, attr, prop]
 })()
+
+// Translations of the user interface. Text is looked up by its English version
+// with _(), which replaces placeholders "{0}", "{1}", etc. with parameters. The
+// language pack is fetched with i18nLoad, before building the user interface.
+let i18nLang = 'en'
+let i18nLocale: string | undefined // For formatting dates/times, undefined for the browser default.
+let i18nLanguages: {Code: string, Name: string}[] = [{Code: 'en', Name: 'English'}]
+let i18nTranslations: {[key: string]: string} = {}
+
+const _ = (s: string, ...args: (string | number)[]): string => {
+	s = i18nTranslations[s] || s
+	args.forEach((a, i) => {
+		s = s.split('{'+i+'}').join(''+a)
+	})
+	return s
+}
+
+// i18nPreference returns the language explicitly chosen by the user, or an empty
+// string if the languages configured in the browser are used.
+const i18nPreference = (): string => {
+	try {
+		return window.localStorage.getItem('language') || ''
+	} catch (err) {
+		return ''
+	}
+}
+
+// i18nPreferenceSet stores the language chosen by the user, an empty string
+// switches back to the browser languages. The page must be reloaded for a new
+// language to be used.
+const i18nPreferenceSet = (lang: string) => {
+	try {
+		if (lang) {
+			window.localStorage.setItem('language', lang)
+		} else {
+			window.localStorage.removeItem('language')
+		}
+	} catch (err) {
+		console.log('storing language preference', err)
+	}
+}
+
+// i18nLoad fetches the language pack for the preferred language. On failure,
+// English is used.
+const i18nLoad = async () => {
+	const browserLangs = navigator.languages || [navigator.language]
+	const lang = [i18nPreference(), ...browserLangs].filter(s => s).join(',')
+	try {
+		const resp = await fetch('i18n.json?lang='+encodeURIComponent(lang))
+		if (!resp.ok) {
+			throw new Error('http status '+resp.status)
+		}
+		const p = await resp.json()
+		i18nLang = p.Language
+		i18nLanguages = p.Languages
+		i18nTranslations = p.Translations || {}
+	} catch (err) {
+		console.log('loading language pack, continuing with english', err)
+	}
+	// Format dates with the regional variant from the browser, e.g. "nl-BE".
+	i18nLocale = browserLangs.find(s => s.split('-')[0].toLowerCase() === i18nLang) || (i18nPreference() ? i18nLang : undefined)
+	document.documentElement.lang = i18nLang
+}
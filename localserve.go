@@ -350,6 +350,18 @@ func writeLocalConfig(log mlog.Log, dir, ip string) (rerr error) {
 	local.WebAPIHTTPS.Enabled = true
 	local.WebAPIHTTPS.Port = 1443
 	local.WebAPIHTTPS.Path = "/webapi/"
+	local.AdminAPIHTTP.Enabled = true
+	local.AdminAPIHTTP.Port = 1080
+	local.AdminAPIHTTP.Path = "/adminapi/"
+	local.AdminAPIHTTPS.Enabled = true
+	local.AdminAPIHTTPS.Port = 1443
+	local.AdminAPIHTTPS.Path = "/adminapi/"
+	local.DAVHTTP.Enabled = true
+	local.DAVHTTP.Port = 1080
+	local.DAVHTTP.Path = "/dav/"
+	local.DAVHTTPS.Enabled = true
+	local.DAVHTTPS.Port = 1443
+	local.DAVHTTPS.Path = "/dav/"
 	local.AdminHTTP.Enabled = true
 	local.AdminHTTP.Port = 1080
 	local.AdminHTTPS.Enabled = true
@@ -452,6 +464,7 @@ func writeLocalConfig(log mlog.Log, dir, ip string) (rerr error) {
 				},
 			},
 		},
+		Version: mox.ConfigDynamicVersion,
 	}
 	var domainsconfBuf bytes.Buffer
 	err = sconf.WriteDocs(&domainsconfBuf, dynamic)
@@ -67,9 +67,11 @@ import (
 	"github.com/mjl-/mox/store"
 	"github.com/mjl-/mox/tlsrpt"
 	"github.com/mjl-/mox/tlsrptdb"
+	"github.com/mjl-/mox/totp"
 	"github.com/mjl-/mox/updates"
 	"github.com/mjl-/mox/webadmin"
 	"github.com/mjl-/mox/webapi"
+	"github.com/mjl-/mox/webauth"
 )
 
 var (
@@ -103,7 +105,14 @@ var commands = []struct {
 	{"stop", cmdStop},
 	{"setaccountpassword", cmdSetaccountpassword},
 	{"setadminpassword", cmdSetadminpassword},
+	{"setadmintotp", cmdSetadmintotp},
 	{"loglevels", cmdLoglevels},
+	{"healthcheck", cmdHealthcheck},
+	{"doctor", cmdDoctor},
+	{"checksend", cmdChecksend},
+	{"checkreceive", cmdCheckreceive},
+	{"top", cmdTop},
+	{"admin reload", cmdAdminReload},
 	{"queue holdrules list", cmdQueueHoldrulesList},
 	{"queue holdrules add", cmdQueueHoldrulesAdd},
 	{"queue holdrules remove", cmdQueueHoldrulesRemove},
@@ -130,14 +139,33 @@ var commands = []struct {
 	{"queue webhook retired print", cmdQueueHookRetiredPrint},
 	{"import maildir", cmdImportMaildir},
 	{"import mbox", cmdImportMbox},
+	{"import eml", cmdImportEML},
+	{"import pst", cmdImportPST},
+	{"import imap", cmdImportIMAP},
 	{"export maildir", cmdExportMaildir},
 	{"export mbox", cmdExportMbox},
+	{"export eml", cmdExportEML},
 	{"localserve", cmdLocalserve},
 	{"help", cmdHelp},
 	{"backup", cmdBackup},
 	{"verifydata", cmdVerifydata},
+	{"verifybackup", cmdVerifybackup},
+	{"extractbackup", cmdExtractbackup},
+	{"restore", cmdRestore},
+	{"fsck", cmdFsck},
+	{"account takeout", cmdAccountTakeout},
+	{"account delete request", cmdAccountDeleteRequest},
+	{"account delete cancel", cmdAccountDeleteCancel},
+	{"account delete purge", cmdAccountDeletePurge},
+	{"account delete list", cmdAccountDeleteList},
+	{"usage", cmdUsage},
+	{"replication standby", cmdReplicationStandby},
+	{"replication status", cmdReplicationStatus},
+	{"preflight listen", cmdPreflightListen},
+	{"preflight check", cmdPreflightCheck},
 
 	{"config test", cmdConfigTest},
+	{"config migrate", cmdConfigMigrate},
 	{"config dnscheck", cmdConfigDNSCheck},
 	{"config dnsrecords", cmdConfigDNSRecords},
 	{"config describe-domains", cmdConfigDescribeDomains},
@@ -148,6 +176,7 @@ var commands = []struct {
 	{"config address rm", cmdConfigAddressRemove},
 	{"config domain add", cmdConfigDomainAdd},
 	{"config domain rm", cmdConfigDomainRemove},
+	{"config addresses", cmdConfigAddresses},
 	{"config alias list", cmdConfigAliasList},
 	{"config alias print", cmdConfigAliasPrint},
 	{"config alias add", cmdConfigAliasAdd},
@@ -155,6 +184,10 @@ var commands = []struct {
 	{"config alias rm", cmdConfigAliasRemove},
 	{"config alias addaddr", cmdConfigAliasAddaddr},
 	{"config alias rmaddr", cmdConfigAliasRemoveaddr},
+	{"config apitoken list", cmdConfigAPITokenList},
+	{"config apitoken add", cmdConfigAPITokenAdd},
+	{"config apitoken rm", cmdConfigAPITokenRemove},
+	{"config apply", cmdConfigApply},
 
 	{"config describe-sendmail", cmdConfigDescribeSendmail},
 	{"config printservice", cmdConfigPrintservice},
@@ -182,6 +215,8 @@ var commands = []struct {
 	{"dmarc checkreportaddrs", cmdDMARCCheckreportaddrs},
 	{"dnsbl check", cmdDNSBLCheck},
 	{"dnsbl checkhealth", cmdDNSBLCheckhealth},
+	{"dnsbl monitor", cmdDNSBLMonitor},
+	{"ech genkey", cmdECHGenkey},
 	{"mtasts lookup", cmdMTASTSLookup},
 	{"retrain", cmdRetrain},
 	{"sendmail", cmdSendmail},
@@ -202,7 +237,12 @@ var commands = []struct {
 	{"ensureparsed", cmdEnsureParsed},
 	{"recalculatemailboxcounts", cmdRecalculateMailboxCounts},
 	{"message parse", cmdMessageParse},
+	{"message trace", cmdMessageTrace},
 	{"reassignthreads", cmdReassignthreads},
+	{"reindex", cmdReindex},
+	{"upgradeaccounts", cmdUpgradeaccounts},
+	{"compressmessages", cmdCompressmessages},
+	{"dedup", cmdDedup},
 
 	// Not listed.
 	{"helpall", cmdHelpall},
@@ -391,7 +431,7 @@ Used to generate documentation.
 func usage(l []cmd, unlisted bool) {
 	var lines []string
 	if !unlisted {
-		lines = append(lines, "mox [-config config/mox.conf] [-pedantic] ...")
+		lines = append(lines, "mox [-config config/mox.conf] [-pedantic] [-remote url] ...")
 	}
 	for _, c := range l {
 		c.gather()
@@ -423,6 +463,9 @@ var pedantic bool
 // restores any loglevel specified on the command-line, instead of using the
 // loglevels from the config file and it does not load files like TLS keys/certs.
 func mustLoadConfig() {
+	if remoteURL != "" {
+		log.Fatalf("command not available with -remote, it needs local access to the configuration or control socket; commands available with -remote: %s", strings.Join(remoteCommands, ", "))
+	}
 	mox.MustLoadConfig(false, false)
 	if level, ok := mlog.Levels[loglevel]; loglevel != "" && ok {
 		mox.Conf.Log[""] = level
@@ -462,6 +505,7 @@ func main() {
 	flag.StringVar(&mox.ConfigStaticPath, "config", envString("MOXCONF", filepath.FromSlash("config/mox.conf")), "configuration file, other config files are looked up in the same directory, defaults to $MOXCONF with a fallback to mox.conf")
 	flag.StringVar(&loglevel, "loglevel", "", "if non-empty, this log level is set early in startup")
 	flag.BoolVar(&pedantic, "pedantic", false, "protocol violations result in errors instead of accepting/working around them")
+	flag.StringVar(&remoteURL, "remote", "", "url of admin api of a remote mox instance, e.g. https://mail.example.com/adminapi/, to run administrative commands remotely instead of through the local control socket, with the api token in $MOXTOKEN")
 	flag.BoolVar(&store.CheckConsistencyOnClose, "checkconsistency", false, "dangerous option for testing only, enables data checks that abort/panic when inconsistencies are found")
 
 	var cpuprofile, memprofile, tracefile string
@@ -555,22 +599,22 @@ configured over otherwise secured connections, like a VPN.
 	}
 	d := xparseDomain(args[0], "domain")
 	mustLoadConfig()
-	printClientConfig(d)
+	printClientConfig(os.Stdout, d)
 }
 
-func printClientConfig(d dns.Domain) {
+func printClientConfig(w io.Writer, d dns.Domain) {
 	cc, err := mox.ClientConfigsDomain(d)
 	xcheckf(err, "getting client config")
-	fmt.Printf("%-20s %-30s %5s %-15s %s\n", "Protocol", "Host", "Port", "Listener", "Note")
+	fmt.Fprintf(w, "%-20s %-30s %5s %-15s %s\n", "Protocol", "Host", "Port", "Listener", "Note")
 	for _, e := range cc.Entries {
-		fmt.Printf("%-20s %-30s %5d %-15s %s\n", e.Protocol, e.Host, e.Port, e.Listener, e.Note)
+		fmt.Fprintf(w, "%-20s %-30s %5d %-15s %s\n", e.Protocol, e.Host, e.Port, e.Listener, e.Note)
 	}
-	fmt.Printf(`
+	fmt.Fprintf(w, `
 To prevent authentication mechanism downgrade attempts that may result in
 clients sending plain text passwords to a MitM, clients should always be
 explicitly configured with the most secure authentication mechanism supported,
-the first of: SCRAM-SHA-256-PLUS, SCRAM-SHA-1-PLUS, SCRAM-SHA-256, SCRAM-SHA-1,
-CRAM-MD5.
+the first of: SCRAM-SHA-512-PLUS, SCRAM-SHA-256-PLUS, SCRAM-SHA-1-PLUS,
+SCRAM-SHA-512, SCRAM-SHA-256, SCRAM-SHA-1, CRAM-MD5.
 `)
 }
 
@@ -601,6 +645,44 @@ are printed.
 	fmt.Println("config OK")
 }
 
+func cmdConfigMigrate(c *cmd) {
+	c.params = "[-dryrun]"
+	c.help = `Migrate domains.conf to the current schema version.
+
+New mox versions can rename or restructure fields in domains.conf. The file has
+a Version field, and files with an older or missing version are migrated when
+parsed, and written by "mox serve" at startup. This command prints the changes,
+and writes them unless -dryrun is set. Run it with a new mox binary to review
+the changes before upgrading. Comments are kept.
+
+Files with includes, environment variables or file references are not written,
+the changes must be made manually.
+`
+	var dryRun bool
+	c.flag.BoolVar(&dryRun, "dryrun", false, "only print the changes, don't write them")
+	args := c.Parse()
+	if len(args) != 0 {
+		c.Usage()
+	}
+
+	mustLoadConfig()
+	m, err := mox.ConfigMigrate(c.log, dryRun)
+	if m.Diff != "" {
+		fmt.Print(m.Diff)
+	}
+	for _, s := range m.Changes {
+		fmt.Println(s)
+	}
+	xcheckf(err, "migrating domains.conf")
+	if len(m.Changes) == 0 {
+		fmt.Println("domains.conf is at current version, no changes")
+	} else if m.Written {
+		fmt.Println("domains.conf written")
+	} else {
+		fmt.Println("dry run, not written")
+	}
+}
+
 func cmdConfigDescribeStatic(c *cmd) {
 	c.params = ">mox.conf"
 	c.help = `Prints an annotated empty configuration for use as mox.conf.
@@ -676,6 +758,14 @@ must be set if and only if account does not yet exist.
 	}
 
 	d := xparseDomain(args[0], "domain")
+	if rc := xremote(); rc != nil {
+		var localpart string
+		if len(args) == 3 {
+			localpart = args[2]
+		}
+		remotecmdConfigDomainAdd(rc, d.Name(), args[1], localpart)
+		return
+	}
 	mustLoadConfig()
 	var localpart smtp.Localpart
 	if len(args) == 3 {
@@ -708,6 +798,10 @@ rejected.
 	}
 
 	d := xparseDomain(args[0], "domain")
+	if rc := xremote(); rc != nil {
+		remotecmdConfigDomainRemove(rc, d.Name())
+		return
+	}
 	mustLoadConfig()
 	ctlcmdConfigDomainRemove(xctl(), d)
 }
@@ -719,6 +813,29 @@ func ctlcmdConfigDomainRemove(ctl *ctl, d dns.Domain) {
 	fmt.Printf("domain removed, remember to remove dns records for %s\n", d)
 }
 
+func cmdConfigAddresses(c *cmd) {
+	c.params = "[domain ...]"
+	c.help = `List addresses of accounts and aliases for domains, one per line.
+
+The list is meant for a secondary MX, for validating recipients before
+accepting messages for a domain. Serve the list over HTTPS, and configure
+it as AddressesURL for the domain in the SecondaryMX section of mox.conf on
+the secondary MX. Catchall addresses are listed as "@domain". Without
+domains, addresses of all domains are listed.
+`
+	args := c.Parse()
+
+	mustLoadConfig()
+	ctlcmdConfigAddresses(xctl(), args)
+}
+
+func ctlcmdConfigAddresses(ctl *ctl, domains []string) {
+	ctl.xwrite("addresslist")
+	ctl.xwrite(strings.Join(domains, " "))
+	ctl.xreadok()
+	ctl.xstreamto(os.Stdout)
+}
+
 func cmdConfigAliasList(c *cmd) {
 	c.params = "domain"
 	c.help = `List aliases for domain.`
@@ -727,6 +844,10 @@ func cmdConfigAliasList(c *cmd) {
 		c.Usage()
 	}
 
+	if rc := xremote(); rc != nil {
+		remotecmdConfigAliasList(rc, args[0])
+		return
+	}
 	mustLoadConfig()
 	ctlcmdConfigAliasList(xctl(), args[0])
 }
@@ -746,6 +867,10 @@ func cmdConfigAliasPrint(c *cmd) {
 		c.Usage()
 	}
 
+	if rc := xremote(); rc != nil {
+		remotecmdConfigAliasPrint(rc, args[0])
+		return
+	}
 	mustLoadConfig()
 	ctlcmdConfigAliasPrint(xctl(), args[0])
 }
@@ -765,6 +890,10 @@ func cmdConfigAliasAdd(c *cmd) {
 		c.Usage()
 	}
 
+	if rc := xremote(); rc != nil {
+		remotecmdConfigAliasAdd(rc, args[0], args[1:])
+		return
+	}
 	alias := config.Alias{Addresses: args[1:]}
 
 	mustLoadConfig()
@@ -791,6 +920,10 @@ func cmdConfigAliasUpdate(c *cmd) {
 	}
 
 	alias := args[0]
+	if rc := xremote(); rc != nil {
+		remotecmdConfigAliasUpdate(rc, alias, postpublic, listmembers, allowmsgfrom)
+		return
+	}
 	mustLoadConfig()
 	ctlcmdConfigAliasUpdate(xctl(), alias, postpublic, listmembers, allowmsgfrom)
 }
@@ -812,6 +945,10 @@ func cmdConfigAliasRemove(c *cmd) {
 		c.Usage()
 	}
 
+	if rc := xremote(); rc != nil {
+		remotecmdConfigAliasRemove(rc, args[0])
+		return
+	}
 	mustLoadConfig()
 	ctlcmdConfigAliasRemove(xctl(), args[0])
 }
@@ -830,6 +967,10 @@ func cmdConfigAliasAddaddr(c *cmd) {
 		c.Usage()
 	}
 
+	if rc := xremote(); rc != nil {
+		remotecmdConfigAliasAddaddr(rc, args[0], args[1:])
+		return
+	}
 	mustLoadConfig()
 	ctlcmdConfigAliasAddaddr(xctl(), args[0], args[1:])
 }
@@ -849,6 +990,10 @@ func cmdConfigAliasRemoveaddr(c *cmd) {
 		c.Usage()
 	}
 
+	if rc := xremote(); rc != nil {
+		remotecmdConfigAliasRmaddr(rc, args[0], args[1:])
+		return
+	}
 	mustLoadConfig()
 	ctlcmdConfigAliasRmaddr(xctl(), args[0], args[1:])
 }
@@ -860,6 +1005,122 @@ func ctlcmdConfigAliasRmaddr(ctl *ctl, alias string, addresses []string) {
 	ctl.xreadok()
 }
 
+func cmdConfigAPITokenList(c *cmd) {
+	c.help = `List tokens for the admin API.`
+	args := c.Parse()
+	if len(args) != 0 {
+		c.Usage()
+	}
+
+	mustLoadConfig()
+	ctlcmdConfigAPITokenList(xctl())
+}
+
+func ctlcmdConfigAPITokenList(ctl *ctl) {
+	ctl.xwrite("apitokenlist")
+	ctl.xreadok()
+	ctl.xstreamto(os.Stdout)
+}
+
+func cmdConfigAPITokenAdd(c *cmd) {
+	c.params = "[-role role] name"
+	c.help = `Add a token for the admin API, and print it.
+
+The role determines which methods the token can call:
+
+- readonly: only methods that don't make changes.
+- queue: read-only methods, and managing the outgoing queue.
+- domains: read-only methods, and managing domains, accounts, addresses and aliases.
+- admin: all methods.
+
+The token is only printed once, mox only stores a hash. Pass it to the admin API
+in an "Authorization: Bearer <token>" HTTP header. The admin API must be enabled
+in a listener with AdminAPIHTTP or AdminAPIHTTPS.
+`
+	var role string
+	c.flag.StringVar(&role, "role", "admin", "role of token: readonly, queue, domains or admin")
+	args := c.Parse()
+	if len(args) != 1 {
+		c.Usage()
+	}
+
+	mustLoadConfig()
+	ctlcmdConfigAPITokenAdd(xctl(), args[0], role)
+}
+
+func ctlcmdConfigAPITokenAdd(ctl *ctl, name, role string) {
+	ctl.xwrite("apitokenadd")
+	ctl.xwrite(name)
+	ctl.xwrite(role)
+	ctl.xreadok()
+	ctl.xstreamto(os.Stdout)
+}
+
+func cmdConfigAPITokenRemove(c *cmd) {
+	c.params = "name"
+	c.help = `Remove a token for the admin API, it can no longer be used.`
+	args := c.Parse()
+	if len(args) != 1 {
+		c.Usage()
+	}
+
+	mustLoadConfig()
+	ctlcmdConfigAPITokenRemove(xctl(), args[0])
+}
+
+func ctlcmdConfigAPITokenRemove(ctl *ctl, name string) {
+	ctl.xwrite("apitokenrm")
+	ctl.xwrite(name)
+	ctl.xreadok()
+}
+
+func cmdConfigApply(c *cmd) {
+	c.params = "[-dryrun] domains.conf"
+	c.help = `Replace domains.conf of the running mox instance with a desired configuration.
+
+The desired configuration must be complete, with all domains, accounts and
+addresses, e.g. generated from a configuration management system. It can be in
+the format of domains.conf, or a JSON object with the same fields. Specify "-"
+to read it from stdin. The configuration is validated, and the domains,
+accounts, addresses and aliases that are added, changed or removed are printed.
+Unless -dryrun is set, domains.conf is then replaced atomically, and the changes
+take effect immediately. Applying the same configuration again makes no changes.
+
+DKIM private keys referenced by the configuration must already exist. Message
+files of removed accounts are not removed.
+`
+	var dryRun bool
+	c.flag.BoolVar(&dryRun, "dryrun", false, "only validate and print the changes, don't apply them")
+	args := c.Parse()
+	if len(args) != 1 {
+		c.Usage()
+	}
+
+	var buf []byte
+	var err error
+	if args[0] == "-" {
+		buf, err = io.ReadAll(os.Stdin)
+	} else {
+		buf, err = os.ReadFile(args[0])
+	}
+	xcheckf(err, "reading desired config")
+
+	if rc := xremote(); rc != nil {
+		remotecmdConfigApply(rc, buf, dryRun)
+		return
+	}
+	mustLoadConfig()
+	ctlcmdConfigApply(xctl(), buf, dryRun)
+}
+
+func ctlcmdConfigApply(ctl *ctl, buf []byte, dryRun bool) {
+	ctl.xwrite("configapply")
+	ctl.xwrite(fmt.Sprintf("%v", dryRun))
+	ctl.xstreamfrom(bytes.NewReader(buf))
+	ctl.xreadok()
+	ctl.xstreamto(os.Stdout)
+}
+
 func cmdConfigAccountAdd(c *cmd) {
 	c.params = "account address"
 	c.help = `Add an account with an email address and reload the configuration.
@@ -872,6 +1133,10 @@ explicitly, see the setaccountpassword command.
 		c.Usage()
 	}
 
+	if rc := xremote(); rc != nil {
+		remotecmdConfigAccountAdd(rc, args[0], args[1])
+		return
+	}
 	mustLoadConfig()
 	ctlcmdConfigAccountAdd(xctl(), args[0], args[1])
 }
@@ -889,13 +1154,18 @@ func cmdConfigAccountRemove(c *cmd) {
 	c.help = `Remove an account and reload the configuration.
 
 Email addresses for this account will also be removed, and incoming email for
-these addresses will be rejected.
+these addresses will be rejected. The data directory of the account is kept, see
+"mox account delete request" for deleting an account including its data.
 `
 	args := c.Parse()
 	if len(args) != 1 {
 		c.Usage()
 	}
 
+	if rc := xremote(); rc != nil {
+		remotecmdConfigAccountRemove(rc, args[0])
+		return
+	}
 	mustLoadConfig()
 	ctlcmdConfigAccountRemove(xctl(), args[0])
 }
@@ -919,6 +1189,10 @@ address for the domain.
 		c.Usage()
 	}
 
+	if rc := xremote(); rc != nil {
+		remotecmdConfigAddressAdd(rc, args[0], args[1])
+		return
+	}
 	mustLoadConfig()
 	ctlcmdConfigAddressAdd(xctl(), args[0], args[1])
 }
@@ -942,6 +1216,10 @@ Incoming email for this address will be rejected after removing an address.
 		c.Usage()
 	}
 
+	if rc := xremote(); rc != nil {
+		remotecmdConfigAddressRemove(rc, args[0])
+		return
+	}
 	mustLoadConfig()
 	ctlcmdConfigAddressRemove(xctl(), args[0])
 }
@@ -1290,6 +1568,18 @@ Valid labels: error, info, debug, trace, traceauth, tracedata.
 	if len(args) > 2 {
 		c.Usage()
 	}
+	if rc := xremote(); rc != nil {
+		if len(args) == 0 {
+			remotecmdLoglevels(rc)
+		} else {
+			var pkg string
+			if len(args) == 2 {
+				pkg = args[1]
+			}
+			remotecmdSetLoglevels(rc, pkg, args[0])
+		}
+		return
+	}
 	mustLoadConfig()
 
 	if len(args) == 0 {
@@ -1316,6 +1606,70 @@ func ctlcmdSetLoglevels(ctl *ctl, pkg, level string) {
 	ctl.xreadok()
 }
 
+func cmdHealthcheck(c *cmd) {
+	c.help = `Check the health of the running mox instance.
+
+Prints a line per check, starting with "ok" or "error". Liveness checks verify
+the listeners are bound and the queue is running. Readiness checks also verify
+all accounts can be opened, ACME certificates do not expire within 7 days, the
+hostname resolves, and that periodic DNS checks of domains, if enabled, found no
+errors.
+
+Exits with status 1 if a check failed, for use as probe in container
+orchestration. The same checks are available over HTTP at /healthz and /readyz
+on the MetricsHTTP listener.
+`
+	var live bool
+	c.flag.BoolVar(&live, "live", false, "only run liveness checks, as for /healthz")
+	args := c.Parse()
+	if len(args) != 0 {
+		c.Usage()
+	}
+	mustLoadConfig()
+	if !ctlcmdHealthcheck(xctl(), live) {
+		os.Exit(1)
+	}
+}
+
+func ctlcmdHealthcheck(ctl *ctl, live bool) bool {
+	ctl.xwrite("healthcheck")
+	ctl.xwrite(fmt.Sprintf("%v", live))
+	ctl.xreadok()
+	healthy := ctl.xread() == "healthy"
+	ctl.xstreamto(os.Stdout)
+	return healthy
+}
+
+func cmdAdminReload(c *cmd) {
+	c.help = `Reload the config file mox.conf of the running mox instance.
+
+The config file and domains.conf are parsed and validated first. If they have
+errors, nothing changes and the errors are printed. Otherwise, the changed lines
+are printed, and changes to fields that can take effect while running, such as
+log levels, spam scanning, transports, alerting and tracing, are applied. Log
+levels changed at runtime are reset to those in the config file. Changes to
+other fields, such as Listeners, ACME and DataDir, are listed as requiring a
+restart. Existing connections are not interrupted.
+
+The same reload is done when mox receives a SIGHUP signal.
+`
+	var preview bool
+	c.flag.BoolVar(&preview, "preview", false, "only print the changes, don't apply them")
+	args := c.Parse()
+	if len(args) != 0 {
+		c.Usage()
+	}
+	mustLoadConfig()
+	ctlcmdAdminReload(xctl(), preview)
+}
+
+func ctlcmdAdminReload(ctl *ctl, preview bool) {
+	ctl.xwrite("reload")
+	ctl.xwrite(fmt.Sprintf("%v", preview))
+	ctl.xreadok()
+	ctl.xstreamto(os.Stdout)
+}
+
 func cmdStop(c *cmd) {
 	c.help = `Shut mox down, giving connections maximum 3 seconds to stop before closing them.
 
@@ -1344,7 +1698,7 @@ new mail deliveries.
 }
 
 func cmdBackup(c *cmd) {
-	c.params = "dest-dir"
+	c.params = "[-verbose] [-incremental previous] [-manifest file] dest-dir"
 	c.help = `Creates a backup of the data directory.
 
 Backup creates consistent snapshots of the databases and message files and
@@ -1368,6 +1722,26 @@ are stored, but with a warning.
 A clean successful backup does not print any output by default. Use the
 -verbose flag for details, including timing.
 
+Each backup contains a file "backup-manifest.json", listing all files in the
+backup with their size, and a SHA-256 hash for files other than messages. For
+databases, a hash of each 64KB chunk is included. The manifest is used by "mox
+verifybackup", and for incremental backups.
+
+With -incremental, a backup is made relative to a previous backup. For a
+destination directory, the previous backup must be a backup directory: message
+files present in the previous backup are hardlinked (or copied) from it instead
+of the data directory. With a destination of "-", the backup is written to
+stdout as a tar file, for storing elsewhere without writing to local disk,
+e.g. "mox backup - | aws s3 cp - s3://bucket/mox-full.tar". The previous backup
+of an incremental stream can be a backup directory or a manifest file, e.g.
+written with -manifest. An incremental stream leaves out message files that were
+in the previous backup, and contains only the changed 64KB chunks of databases,
+in files with a ".delta" suffix. To restore, the full backup and each
+incremental backup must be applied in order. The -manifest flag writes a copy of
+the manifest to a file, for keeping next to the data directory as reference for
+the next incremental backup. Log output is not written to stdout when streaming,
+but is available in the mox logs.
+
 To restore a backup, first shut down mox, move away the old data directory and
 move an earlier backed up directory in its place, run "mox verifydata",
 possibly with the "-fix" option, and restart mox. After the restore, you may
@@ -1382,20 +1756,33 @@ upgrading.
 `
 
 	var verbose bool
+	var previous, manifest string
 	c.flag.BoolVar(&verbose, "verbose", false, "print progress")
+	c.flag.StringVar(&previous, "incremental", "", "previous backup directory or manifest file to make an incremental backup against")
+	c.flag.StringVar(&manifest, "manifest", "", "also write manifest of backup to file")
 	args := c.Parse()
 	if len(args) != 1 {
 		c.Usage()
 	}
 	mustLoadConfig()
 
-	dstDataDir, err := filepath.Abs(args[0])
-	xcheckf(err, "making path absolute")
+	abs := func(p string) string {
+		if p == "" {
+			return ""
+		}
+		p, err := filepath.Abs(p)
+		xcheckf(err, "making path absolute")
+		return p
+	}
+	dstDataDir := args[0]
+	if dstDataDir != "-" {
+		dstDataDir = abs(dstDataDir)
+	}
 
-	ctlcmdBackup(xctl(), dstDataDir, verbose)
+	ctlcmdBackup(xctl(), dstDataDir, verbose, abs(previous), abs(manifest))
 }
 
-func ctlcmdBackup(ctl *ctl, dstDataDir string, verbose bool) {
+func ctlcmdBackup(ctl *ctl, dstDataDir string, verbose bool, previous, manifest string) {
 	ctl.xwrite("backup")
 	ctl.xwrite(dstDataDir)
 	if verbose {
@@ -1403,6 +1790,8 @@ func ctlcmdBackup(ctl *ctl, dstDataDir string, verbose bool) {
 	} else {
 		ctl.xwrite("")
 	}
+	ctl.xwrite(previous)
+	ctl.xwrite(manifest)
 	ctl.xstreamto(os.Stdout)
 	ctl.xreadok()
 }
@@ -1432,6 +1821,48 @@ The password is read from stdin. Its bcrypt hash is stored in a file named
 	xcheckf(err, "writing hash to admin password file")
 }
 
+func cmdSetadmintotp(c *cmd) {
+	c.params = "[-disable]"
+	c.help = `Set up two-factor authentication for admin logins to the web interface.
+
+A new secret for time-based one-time passwords (TOTP) is generated, and printed
+as otpauth URI for adding to an authenticator app. A code from the app is read
+from stdin to confirm the setup. The secret is stored in a file named
+"adminpasswd.totp" (next to the admin password file) in the configuration
+directory. Once set up, admin logins require a code from the app.
+
+With -disable, the file is removed and admin logins only require the password.
+`
+	var disable bool
+	c.flag.BoolVar(&disable, "disable", false, "disable two-factor authentication for admin logins")
+	if len(c.Parse()) != 0 {
+		c.Usage()
+	}
+	mustLoadConfig()
+
+	path := webauth.AdminTOTPPath()
+	if path == "" {
+		log.Fatal("no admin password file configured")
+	}
+	if disable {
+		err := os.Remove(path)
+		xcheckf(err, "removing admin totp file")
+		return
+	}
+
+	secret := totp.NewSecret()
+	fmt.Printf("Add the following URI to your authenticator app:\n\n%s\n\nSecret for manual entry: %s\n\n", totp.URI(mox.Conf.Static.HostnameDomain.ASCII, "admin", secret), totp.EncodeSecret(secret))
+	fmt.Printf("code: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	xcheckf(err, "reading code from stdin")
+	if _, ok := totp.Verify(secret, strings.TrimSpace(line), time.Now(), 0); !ok {
+		log.Fatalf("invalid code, two-factor authentication not enabled")
+	}
+	err = os.WriteFile(path, []byte(totp.EncodeSecret(secret)+"\n"), 0660)
+	xcheckf(err, "writing admin totp file")
+	fmt.Println("two-factor authentication enabled for admin logins")
+}
+
 func xreadpassword() string {
 	fmt.Printf(`
 Type new password. Password WILL echo.
@@ -1461,8 +1892,8 @@ func cmdSetaccountpassword(c *cmd) {
 
 The password is read from stdin. Secrets derived from the password, but not the
 password itself, are stored in the account database. The stored secrets are for
-authentication with: scram-sha-256, scram-sha-1, cram-md5, plain text (bcrypt
-hash).
+authentication with: scram-sha-512, scram-sha-256, scram-sha-1, cram-md5, plain
+text (bcrypt hash).
 
 The parameter is an account name, as configured under Accounts in domains.conf
 and as present in the data/accounts/ directory, not a configured email address
@@ -1472,6 +1903,10 @@ for an account.
 	if len(args) != 1 {
 		c.Usage()
 	}
+	if rc := xremote(); rc != nil {
+		remotecmdSetaccountpassword(rc, args[0], xreadpassword())
+		return
+	}
 	mustLoadConfig()
 
 	pw := xreadpassword()
@@ -2066,6 +2501,37 @@ so it is recommended to sign messages with both RSA and ed25519 keys.
 	xcheckf(err, "writing dkim ed25519 key")
 }
 
+func cmdECHGenkey(c *cmd) {
+	c.params = "publicname >ech.$publicname.pem"
+	c.help = `Generate a new key for Encrypted Client Hello (ECH).
+
+The PEM file written to stdout contains an X25519 private key and the
+ECHConfigList with the public key. The file can be configured in ECHKeyFiles of
+a listener.
+
+The public name is the host name clients connect to in the outer (unencrypted)
+client hello, typically the host name of the listener. Mox must have a valid
+certificate for the name.
+
+Clients only use ECH after finding the ECHConfigList in DNS. The DNS record to
+add is printed to stderr.
+`
+	args := c.Parse()
+	if len(args) != 1 {
+		c.Usage()
+	}
+
+	name, err := dns.ParseDomain(args[0])
+	xcheckf(err, "parsing public name")
+	var id [1]byte
+	cryptorand.Read(id[:])
+	buf, configList, err := mox.MakeECHKey(name.ASCII, id[0])
+	xcheckf(err, "making ech key")
+	_, err = os.Stdout.Write(buf)
+	xcheckf(err, "writing ech key")
+	fmt.Fprintf(os.Stderr, "%s. HTTPS 1 . alpn=h2,http/1.1 ech=%s\n", name.ASCII, base64.StdEncoding.EncodeToString(configList))
+}
+
 func cmdDKIMTXT(c *cmd) {
 	c.params = "<$selector._domainkey.$domain.key.pkcs8.pem"
 	c.help = `Print a DKIM DNS TXT record with the public key derived from the private key read from stdin.
@@ -2390,7 +2856,7 @@ address must opt-in to receiving DMARC reports by creating a DMARC record at
 
 		u, err := url.Parse(addr)
 		if err != nil {
-			printResult("parsing uri: %v (skipping)", addr, err)
+			printResult("parsing uri: %v (skipping)", err)
 			return
 		}
 		var destdom dns.Domain
@@ -2658,19 +3124,30 @@ should be used, and how long the policy can be cached.
 }
 
 func cmdRetrain(c *cmd) {
-	c.params = "accountname"
+	c.params = "[-backlog] accountname"
 	c.help = `Recreate and retrain the junk filter for the account.
 
 Useful after having made changes to the junk filter configuration, or if the
 implementation has changed.
+
+With -backlog, the junk filter is not recreated, but only messages with
+junk/nonjunk flags that changed since they were last trained are retrained. For
+accounts with DelayFlagTraining in the junk filter configuration, this happens
+automatically once a day.
 `
+	var backlog bool
+	c.flag.BoolVar(&backlog, "backlog", false, "only retrain messages with changed junk/nonjunk flags")
 	args := c.Parse()
 	if len(args) != 1 {
 		c.Usage()
 	}
 
 	mustLoadConfig()
-	ctlcmdRetrain(xctl(), args[0])
+	if backlog {
+		ctlcmdRetrainBacklog(xctl(), args[0])
+	} else {
+		ctlcmdRetrain(xctl(), args[0])
+	}
 }
 
 func ctlcmdRetrain(ctl *ctl, account string) {
@@ -2679,6 +3156,13 @@ func ctlcmdRetrain(ctl *ctl, account string) {
 	ctl.xreadok()
 }
 
+func ctlcmdRetrainBacklog(ctl *ctl, account string) {
+	ctl.xwrite("retrainbacklog")
+	ctl.xwrite(account)
+	ctl.xreadok()
+	fmt.Printf("retrained %s messages\n", ctl.xread())
+}
+
 func cmdTLSRPTDBAddReport(c *cmd) {
 	c.unlisted = true
 	c.params = "< message"
@@ -2731,7 +3215,7 @@ URL with more information.
 	status, explanation, err := dnsbl.Lookup(context.Background(), c.log.Logger, dns.StrictResolver{}, zone, ip)
 	fmt.Printf("status: %s\n", status)
 	if status == dnsbl.StatusFail {
-		fmt.Printf("explanation: %q\n", explanation)
+		fmt.Printf("explanation: %q\n\n%s\n", explanation, dnsbl.DelistText(zone, ip.String()))
 	}
 	if err != nil {
 		fmt.Printf("error: %s\n", err)
@@ -2756,6 +3240,42 @@ The health of a DNS blocklist can be checked by querying for 127.0.0.1 and
 	fmt.Println("healthy")
 }
 
+func cmdDNSBLMonitor(c *cmd) {
+	c.params = "[-check] [-history n]"
+	c.help = `Print the status of our IPs in DNS blocklists, and the history of changes.
+
+A running mox periodically checks the public IPs it can send from against the
+DNSBLs of the SMTP listeners in mox.conf, and those in MonitorDNSBLs in
+domains.conf: every 3 hours, or sooner when many messages are delivered. Changes
+in listing status are recorded and kept as history. A new listing raises an
+alert, if alerting is configured in mox.conf.
+
+For each listing, guidance for delisting is printed, with the delisting
+procedure of well-known blocklists.
+
+With -check, the IPs are checked immediately instead of printing the status of
+the most recent periodic check.
+`
+	var check bool
+	history := 25
+	c.flag.BoolVar(&check, "check", false, "check the IPs now")
+	c.flag.IntVar(&history, "history", history, "maximum number of changes in history to print, 0 for all")
+	args := c.Parse()
+	if len(args) != 0 {
+		c.Usage()
+	}
+	mustLoadConfig()
+	ctlcmdDNSBLMonitor(xctl(), check, history)
+}
+
+func ctlcmdDNSBLMonitor(ctl *ctl, check bool, history int) {
+	ctl.xwrite("dnsblmonitor")
+	ctl.xwrite(fmt.Sprintf("%v", check))
+	ctl.xwrite(fmt.Sprintf("%d", history))
+	ctl.xreadok()
+	ctl.xstreamto(os.Stdout)
+}
+
 func cmdCheckupdate(c *cmd) {
 	c.help = `Check if a newer version of mox is available.
 
@@ -3224,7 +3744,7 @@ func cmdEnsureParsed(c *cmd) {
 		q := bstore.QueryTx[store.Message](tx)
 		q.FilterEqual("Expunged", false)
 		q.FilterFn(func(m store.Message) bool {
-			return all || m.ParsedBuf == nil
+			return all || m.Sealed.ParsedBuf == nil
 		})
 		l, err := q.List()
 		if err != nil {
@@ -3236,7 +3756,7 @@ func cmdEnsureParsed(c *cmd) {
 			if err != nil {
 				log.Printf("parsing message %d: %v (continuing)", m.ID, err)
 			}
-			m.ParsedBuf, err = json.Marshal(p)
+			m.Sealed.ParsedBuf, err = json.Marshal(p)
 			if err != nil {
 				return fmt.Errorf("marshal parsed message: %v", err)
 			}
@@ -3334,6 +3854,31 @@ func cmdMessageParse(c *cmd) {
 	}
 }
 
+func cmdMessageTrace(c *cmd) {
+	c.params = "id"
+	c.help = `Print the delivery history of a message.
+
+The id is a Message-ID, with or without <>, or the ID of a message in the
+queue. Events are printed oldest first: receipt over SMTP, matching rulesets,
+junk filter classification, delivery to mailboxes or rejection, queueing, and
+delivery attempts from the queue with the response from the remote server.
+Events are kept for 30 days.
+`
+	args := c.Parse()
+	if len(args) != 1 {
+		c.Usage()
+	}
+	mustLoadConfig()
+	ctlcmdMessageTrace(xctl(), args[0])
+}
+
+func ctlcmdMessageTrace(ctl *ctl, id string) {
+	ctl.xwrite("messagetrace")
+	ctl.xwrite(id)
+	ctl.xreadok()
+	ctl.xstreamto(os.Stdout)
+}
+
 func cmdOpenaccounts(c *cmd) {
 	c.unlisted = true
 	c.params = "datadir account ..."
@@ -3412,6 +3957,154 @@ func ctlcmdReassignthreads(ctl *ctl, account string) {
 	ctl.xstreamto(os.Stdout)
 }
 
+func cmdReindex(c *cmd) {
+	c.params = "[-steps parse,threads,counts] [-restart] [account]"
+	c.help = `Rebuild data derived from messages in the account or all accounts.
+
+Steps, executed in this order:
+
+- parse: parse the message files again, storing the MIME structure that is used
+  when searching and fetching messages.
+- threads: reset and assign threads for all messages.
+- counts: recalculate the message counts and sizes of mailboxes and accounts.
+
+Messages are processed in batches while mox is running, so accounts remain
+accessible. Progress is printed and stored in the account database. If a
+reindex is interrupted, e.g. by a restart of mox, running the command again
+resumes the remaining steps, with parsing continuing after the last processed
+message. Use -restart to start over with the given steps.
+
+Mox does not maintain a full-text index, searches read the parsed messages.
+`
+	steps := strings.Join(store.ReindexSteps, ",")
+	var restart bool
+	c.flag.StringVar(&steps, "steps", steps, "comma-separated steps to run")
+	c.flag.BoolVar(&restart, "restart", false, "start over instead of resuming an interrupted reindex")
+	args := c.Parse()
+	if len(args) > 1 {
+		c.Usage()
+	}
+
+	mustLoadConfig()
+	var account string
+	if len(args) == 1 {
+		account = args[0]
+	}
+	ctlcmdReindex(xctl(), account, steps, restart)
+}
+
+func ctlcmdReindex(ctl *ctl, account, steps string, restart bool) {
+	ctl.xwrite("reindex")
+	ctl.xwrite(account)
+	ctl.xwrite(steps)
+	ctl.xwrite(fmt.Sprintf("%v", restart))
+	ctl.xreadok()
+	ctl.xstreamto(os.Stdout)
+}
+
+func cmdUpgradeaccounts(c *cmd) {
+	c.params = "[account]"
+	c.help = `Apply pending database upgrades for the account or all accounts.
+
+Accounts are opened by mox when first used, e.g. when a user logs in or a
+message is delivered. Changes to the database schema after an upgrade of mox
+are applied when opening the account, and one-time data upgrades, such as
+assigning threads to all messages, run in the background. For large accounts,
+this can take a while, delaying the first use.
+
+This command opens the accounts one by one in the running mox, and waits for
+the upgrades to complete, printing progress. Run it after upgrading mox, before
+users access their accounts. Accounts with an interrupted "mox reindex" are
+listed.
+`
+	args := c.Parse()
+	if len(args) > 1 {
+		c.Usage()
+	}
+
+	mustLoadConfig()
+	var account string
+	if len(args) == 1 {
+		account = args[0]
+	}
+	ctlcmdUpgradeaccounts(xctl(), account)
+}
+
+func ctlcmdUpgradeaccounts(ctl *ctl, account string) {
+	ctl.xwrite("upgradeaccounts")
+	ctl.xwrite(account)
+	ctl.xreadok()
+	ctl.xstreamto(os.Stdout)
+}
+
+func cmdCompressmessages(c *cmd) {
+	c.params = "[account]"
+	c.help = `Compress existing message files in the account or all accounts.
+
+New message files are only compressed if CompressMessages is enabled in mox.conf
+or for the account. This command compresses the message files stored earlier.
+Compressed files are also encrypted if encryption at rest is configured.
+Message files are replaced one by one, in batches, so other access to the
+messages is not blocked.
+
+Statistics about the space saved are printed for each account, also for message
+files that were already compressed.
+`
+	args := c.Parse()
+	if len(args) > 1 {
+		c.Usage()
+	}
+
+	mustLoadConfig()
+	var account string
+	if len(args) == 1 {
+		account = args[0]
+	}
+	ctlcmdCompressmessages(xctl(), account)
+}
+
+func ctlcmdCompressmessages(ctl *ctl, account string) {
+	ctl.xwrite("compressmessages")
+	ctl.xwrite(account)
+	ctl.xreadok()
+	ctl.xstreamto(os.Stdout)
+}
+
+func cmdDedup(c *cmd) {
+	c.params = "[account]"
+	c.help = `Deduplicate existing message files in the account or all accounts.
+
+Identical message files, e.g. of a message delivered to multiple accounts, are
+replaced with hard links to a single file. New message files are only
+deduplicated if DeduplicateMessages is enabled in mox.conf. Encrypted message
+files are not deduplicated. Message files are replaced one by one, in batches,
+so other access to the messages is not blocked. Unused files in the blobs
+directory are removed afterwards.
+
+For each account, the size on disk of its message files is printed, and the
+size attributed to the account, with the size of each shared file divided
+between the messages sharing it.
+`
+	args := c.Parse()
+	if len(args) > 1 {
+		c.Usage()
+	}
+
+	mustLoadConfig()
+	var account string
+	if len(args) == 1 {
+		account = args[0]
+	}
+	ctlcmdDedup(xctl(), account)
+}
+
+func ctlcmdDedup(ctl *ctl, account string) {
+	ctl.xwrite("dedup")
+	ctl.xwrite(account)
+	ctl.xreadok()
+	ctl.xstreamto(os.Stdout)
+}
+
 func cmdReadmessages(c *cmd) {
 	c.unlisted = true
 	c.params = "datadir account ..."
@@ -3463,7 +4156,7 @@ Opens database files directly, not going through a running mox instance.
 					HeaderOffset int64
 					BodyOffset   int64
 				}
-				if err := json.Unmarshal(m.ParsedBuf, &partialPart); err != nil {
+				if err := json.Unmarshal(m.Sealed.ParsedBuf, &partialPart); err != nil {
 					w.Err = fmt.Errorf("unmarshal part: %v", err)
 				} else {
 					size := partialPart.BodyOffset - partialPart.HeaderOffset
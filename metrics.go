@@ -11,6 +11,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 
+	"github.com/mjl-/mox/contentbl"
 	"github.com/mjl-/mox/dane"
 	"github.com/mjl-/mox/dkim"
 	"github.com/mjl-/mox/dmarc"
@@ -20,10 +21,13 @@ import (
 	"github.com/mjl-/mox/metrics"
 	"github.com/mjl-/mox/mlog"
 	"github.com/mjl-/mox/mtasts"
+	"github.com/mjl-/mox/oidc"
 	"github.com/mjl-/mox/smtpclient"
+	"github.com/mjl-/mox/spamscan"
 	"github.com/mjl-/mox/spf"
 	"github.com/mjl-/mox/subjectpass"
 	"github.com/mjl-/mox/tlsrpt"
+	"github.com/mjl-/mox/tracing"
 	"github.com/mjl-/mox/updates"
 )
 
@@ -137,6 +141,16 @@ func init() {
 		),
 	}
 
+	dns.MetricCache = counterVec{promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mox_dns_cache_total",
+			Help: "DNS lookups through the cache, by result.",
+		},
+		[]string{
+			"result", // hit, negative (hit for a name that doesn't exist), shared (with concurrent lookup), miss
+		},
+	)}
+
 	dnsbl.MetricLookup = histogramVec{promauto.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Name:    "mox_dnsbl_lookup_duration_seconds",
@@ -149,6 +163,31 @@ func init() {
 		},
 	)}
 
+	contentbl.MetricLookup = histogramVec{promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "mox_contentbl_uribl_lookup_duration_seconds",
+			Help:    "URI block list lookups of domains of URLs in incoming messages.",
+			Buckets: []float64{0.001, 0.005, 0.01, 0.05, 0.100, 0.5, 1, 5, 10, 20},
+		},
+		[]string{
+			"zone",
+			"status", // pass, fail, temperror
+		},
+	)}
+
+	spamscan.MetricScan = histogramVec{promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "mox_spamscan_duration_seconds",
+			Help:    "Scans of incoming messages by external spam scanners.",
+			Buckets: []float64{0.01, 0.05, 0.100, 0.5, 1, 5, 10, 20, 30},
+		},
+		[]string{
+			"scanner", // rspamd, spamd
+			"result",  // ham, spam, error
+		},
+	)}
+	spamscan.HTTPClientObserve = httpClientObserve
+
 	iprev.MetricIPRev = histogramVec{promauto.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Name:    "mox_iprev_lookup_total",
@@ -169,6 +208,7 @@ func init() {
 		},
 	)}
 	mtasts.HTTPClientObserve = httpClientObserve
+	oidc.HTTPClientObserve = httpClientObserve
 
 	smtpclient.MetricCommands = histogramVec{promauto.NewHistogramVec(
 		prometheus.HistogramOpts{
@@ -231,6 +271,16 @@ func init() {
 		[]string{"result"},
 	)}
 
+	tracing.MetricSpans = counterVec{promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mox_tracing_spans_total",
+			Help: "Spans of OpenTelemetry traces, by export result.",
+		},
+		[]string{
+			"result", // exported, dropped, error
+		},
+	)}
+
 	updates.MetricLookup = histogramVec{promauto.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Name:    "mox_updates_lookup_duration_seconds",
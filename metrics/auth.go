@@ -2,6 +2,8 @@
 package metrics
 
 import (
+	"sync/atomic"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
@@ -13,10 +15,21 @@ var (
 			Help: "Authentication attempts and results.",
 		},
 		[]string{
-			"kind",    // submission, imap, webmail, webapi, webaccount, webadmin (formerly httpaccount, httpadmin)
-			"variant", // login, plain, scram-sha-256, scram-sha-1, cram-md5, weblogin, websessionuse, httpbasic.
+			"kind",    // submission, imap, webmail, webapi, webaccount, webadmin (formerly httpaccount, httpadmin), webserver
+			"variant", // login, plain, scram-sha-512, scram-sha-256, scram-sha-1, cram-md5, weblogin, websessionuse, httpbasic.
 			// todo: we currently only use badcreds, but known baduser can be helpful
-			"result", // ok, baduser, badpassword, badcreds, error, aborted
+			"result", // ok, baduser, badpassword, badcreds, error, aborted, totprequired
+		},
+	)
+
+	metricAuthListenerFailed = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mox_authentication_listener_failed_total",
+			Help: "Failed authentication attempts per listener, for protocols on listeners.",
+		},
+		[]string{
+			"kind",     // submission, imap
+			"listener", // Name of listener in mox.conf.
 		},
 	)
 
@@ -26,15 +39,38 @@ var (
 			Help: "Authentication attempts that were refused due to rate limiting.",
 		},
 		[]string{
-			"kind", // submission, imap, httpaccount, httpadmin
+			"kind", // submission, imap, httpaccount, httpadmin, webserver
 		},
 	)
 )
 
+// Failed and rate limited authentication attempts, for alerting.
+var authFailures atomic.Int64
+
 func AuthenticationInc(kind, variant, result string) {
 	metricAuth.WithLabelValues(kind, variant, result).Inc()
+	switch result {
+	case "baduser", "badpassword", "badcreds":
+		authFailures.Add(1)
+	}
+}
+
+// AuthenticationFailures returns the number of failed and rate limited
+// authentication attempts since startup, over all kinds.
+func AuthenticationFailures() int64 {
+	return authFailures.Load()
+}
+
+// AuthenticationListenerInc counts a failed authentication attempt for a
+// listener, if result indicates a failure.
+func AuthenticationListenerInc(kind, listener, result string) {
+	switch result {
+	case "baduser", "badpassword", "badcreds":
+		metricAuthListenerFailed.WithLabelValues(kind, listener).Inc()
+	}
 }
 
 func AuthenticationRatelimitedInc(kind string) {
 	metricAuthRatelimited.WithLabelValues(kind).Inc()
+	authFailures.Add(1)
 }
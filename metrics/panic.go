@@ -36,11 +36,24 @@ const (
 	Store            Panic = "store"
 	Webadmin         Panic = "webadmin"
 	Webapi           Panic = "webapi"
+	Adminapi         Panic = "adminapi"
 	Webmailsendevent Panic = "webmailsendevent"
 	Webmail          Panic = "webmail"
 	Webmailrequest   Panic = "webmailrequest"
 	Webmailquery     Panic = "webmailquery"
 	Webmailhandle    Panic = "webmailhandle"
+	Dav              Panic = "dav"
+	Webpush          Panic = "webpush"
+	Retention        Panic = "retention"
+	Accountdel       Panic = "accountdel"
+	Quarantine       Panic = "quarantine"
+	Alert            Panic = "alert"
+	Secondarymx      Panic = "secondarymx"
+	Autotls          Panic = "autotls"
+	OCSP             Panic = "ocsp"
+	Mtastspromote    Panic = "mtastspromote"
+	Moderation       Panic = "moderation"
+	Accounting       Panic = "accounting"
 )
 
 func init() {
@@ -61,11 +74,23 @@ func init() {
 		Importmanage,
 		Importmessages,
 		Webadmin,
+		Adminapi,
 		Webmailsendevent,
 		Webmail,
 		Webmailrequest,
 		Webmailquery,
 		Webmailhandle,
+		Dav,
+		Webpush,
+		Retention,
+		Accountdel,
+		Quarantine,
+		Secondarymx,
+		Autotls,
+		OCSP,
+		Mtastspromote,
+		Moderation,
+		Accounting,
 	}
 	for _, name := range names {
 		metricPanic.WithLabelValues(string(name)).Add(0)
@@ -33,6 +33,11 @@ var noctx = context.Background()
 // command-line tools. Must be set early in a program lifecycle.
 var Logfmt bool
 
+// JSON enables output as JSON objects, one per line, with fields "time", "level",
+// "msg", "pkg" and the attributes. Takes precedence over Logfmt. Must be set early
+// in a program lifecycle.
+var JSON bool
+
 // LogStringer is used when formatting field values during logging. If a value
 // implements it, LogString is called for the value to log.
 type LogStringer interface {
@@ -489,7 +494,15 @@ func (h *handler) write(l slog.Level, r slog.Record) error {
 	b := bytes.NewBuffer(buf[:0])
 	eb := &errWriter{b, nil}
 
-	if Logfmt {
+	var fields []Field
+	if JSON || recentEnabled() {
+		fields = h.fields(r)
+		recentAdd(h.Pkgs, r, fields)
+	}
+
+	if JSON {
+		writeJSON(eb, h.Pkgs, r, fields)
+	} else if Logfmt {
 		var wrotePkgs bool
 		ensurePkgs := func() {
 			if !wrotePkgs {
@@ -562,6 +575,9 @@ func (h *handler) write(l slog.Level, r slog.Record) error {
 	}
 
 	// todo: for mox serve, do writes in separate goroutine.
+	if sw := syslogWriter.Load(); sw != nil {
+		return sw.write(r.Level, b.Bytes())
+	}
 	_, err := os.Stderr.Write(b.Bytes())
 	return err
 }
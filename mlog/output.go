@@ -0,0 +1,206 @@
+package mlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Field is an attribute of a logged line, with its value formatted as for logfmt
+// output. Attributes in groups have dot-separated keys.
+type Field struct {
+	Key   string
+	Value string
+
+	raw any // For JSON output, e.g. numbers and booleans.
+}
+
+// fields returns the attributes of a record, followed by those of the handler.
+func (h *handler) fields(r slog.Record) []Field {
+	var l []Field
+	var add func(group string, a slog.Attr)
+	add = func(group string, a slog.Attr) {
+		if a.Value.Kind() == slog.KindGroup {
+			if group != "" {
+				group += "."
+			}
+			group += a.Key
+			for _, ga := range a.Value.Group() {
+				add(group, ga)
+			}
+			return
+		}
+		v := a.Value.Resolve()
+		f := Field{Key: group + a.Key, Value: stringValue(a.Key == "cid", false, v.Any())}
+		switch v.Kind() {
+		case slog.KindInt64, slog.KindUint64, slog.KindFloat64, slog.KindBool:
+			if a.Key != "cid" {
+				f.raw = v.Any()
+			}
+		}
+		l = append(l, f)
+	}
+	group := ""
+	if h.Group != "" {
+		group = h.Group[:len(h.Group)-1]
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		add(group, a)
+		return true
+	})
+	for _, a := range h.Attrs {
+		add(group, a)
+	}
+	if h.Fn != nil {
+		for _, a := range h.Fn() {
+			add(group, a)
+		}
+	}
+	return l
+}
+
+func writeJSON(w io.Writer, pkgs []string, r slog.Record, fields []Field) {
+	t := r.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	var b bytes.Buffer
+	kv := func(k string, v any) {
+		if b.Len() == 0 {
+			b.WriteByte('{')
+		} else {
+			b.WriteByte(',')
+		}
+		kbuf, _ := json.Marshal(k)
+		vbuf, err := json.Marshal(v)
+		if err != nil {
+			vbuf, _ = json.Marshal(fmt.Sprint(v))
+		}
+		b.Write(kbuf)
+		b.WriteByte(':')
+		b.Write(vbuf)
+	}
+	kv("time", t.Format(time.RFC3339Nano))
+	kv("level", LevelStrings[r.Level])
+	kv("msg", r.Message)
+	if len(pkgs) > 0 {
+		kv("pkg", pkgs[len(pkgs)-1])
+	}
+	for _, f := range fields {
+		if f.raw != nil {
+			kv(f.Key, f.raw)
+		} else {
+			kv(f.Key, f.Value)
+		}
+	}
+	b.WriteString("}\n")
+	w.Write(b.Bytes())
+}
+
+// syslogWriter is set when logging to syslog instead of stderr.
+var syslogWriter atomic.Pointer[sysloggerWriter]
+
+type sysloggerWriter struct {
+	network, address, tag string
+
+	sync.Mutex
+	conn net.Conn
+}
+
+// SetSyslog sends log lines to syslog instead of stderr. If network is empty, the
+// local syslog daemon is used through its unix domain socket. Otherwise network is
+// "udp", "tcp" or "unixgram", with address the address to send to. Lines are sent
+// with facility "daemon", and a severity based on the level. Tag is added to each
+// message, typically the program name.
+func SetSyslog(network, address, tag string) error {
+	sw := &sysloggerWriter{network: network, address: address, tag: tag}
+	if err := sw.connect(); err != nil {
+		return err
+	}
+	if prev := syslogWriter.Swap(sw); prev != nil {
+		prev.Lock()
+		prev.conn.Close()
+		prev.Unlock()
+	}
+	return nil
+}
+
+func (sw *sysloggerWriter) connect() error {
+	if sw.network != "" {
+		conn, err := net.DialTimeout(sw.network, sw.address, 5*time.Second)
+		if err != nil {
+			return fmt.Errorf("dial syslog: %w", err)
+		}
+		sw.conn = conn
+		return nil
+	}
+	var errs []error
+	for _, path := range []string{"/dev/log", "/var/run/syslog", "/var/run/log"} {
+		for _, network := range []string{"unixgram", "unix"} {
+			conn, err := net.Dial(network, path)
+			if err == nil {
+				sw.conn = conn
+				return nil
+			}
+			errs = append(errs, err)
+		}
+	}
+	return fmt.Errorf("connecting to local syslog: %w", errors.Join(errs...))
+}
+
+// severity returns the syslog severity for a log level.
+func severity(level slog.Level) int {
+	switch {
+	case level == LevelPrint:
+		return 5 // Notice.
+	case level >= LevelFatal:
+		return 2 // Critical.
+	case level >= LevelError:
+		return 3
+	case level >= LevelWarn:
+		return 4
+	case level >= LevelInfo:
+		return 6
+	}
+	return 7 // Debug.
+}
+
+func (sw *sysloggerWriter) write(level slog.Level, line []byte) error {
+	const facilityDaemon = 3
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	// The local syslog daemon adds the hostname itself.
+	var hostname string
+	if sw.network != "" {
+		hostname, _ = os.Hostname()
+		hostname += " "
+	}
+	msg := fmt.Sprintf("<%d>%s %s%s[%d]: %s", facilityDaemon*8+severity(level), time.Now().Format(time.Stamp), hostname, sw.tag, os.Getpid(), line)
+	if sw.network == "tcp" {
+		// Octet counting framing, RFC 6587.
+		msg = fmt.Sprintf("%d %s", len(msg), msg)
+	}
+
+	sw.Lock()
+	defer sw.Unlock()
+	_, err := sw.conn.Write([]byte(msg))
+	if err == nil {
+		return nil
+	}
+	// Reconnect once, e.g. after a restart of the syslog daemon.
+	sw.conn.Close()
+	if cerr := sw.connect(); cerr != nil {
+		os.Stderr.Write(line)
+		os.Stderr.Write([]byte("\n"))
+		return cerr
+	}
+	_, err = sw.conn.Write([]byte(msg))
+	return err
+}
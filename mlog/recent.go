@@ -0,0 +1,71 @@
+package mlog
+
+import (
+	"log/slog"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Entry is a logged line, as kept in memory for recent log entries.
+type Entry struct {
+	Time    time.Time
+	Level   string
+	Pkgs    []string // Packages, from least to most specific.
+	Message string
+	Fields  []Field
+}
+
+var recentSize atomic.Int64 // For quick check whether lines are kept.
+
+var recent struct {
+	sync.Mutex
+	entries []Entry // Ring buffer, with next entry at next.
+	next    int
+	full    bool
+}
+
+// SetRecent keeps the n most recent logged lines in memory, for retrieval with
+// Recent. If n is 0, no lines are kept.
+func SetRecent(n int) {
+	recent.Lock()
+	defer recent.Unlock()
+	recent.entries = make([]Entry, n)
+	recent.next = 0
+	recent.full = false
+	recentSize.Store(int64(n))
+}
+
+func recentEnabled() bool {
+	return recentSize.Load() > 0
+}
+
+func recentAdd(pkgs []string, r slog.Record, fields []Field) {
+	recent.Lock()
+	defer recent.Unlock()
+	if len(recent.entries) == 0 {
+		return
+	}
+	t := r.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	recent.entries[recent.next] = Entry{t, LevelStrings[r.Level], slices.Clone(pkgs), r.Message, fields}
+	recent.next++
+	if recent.next == len(recent.entries) {
+		recent.next = 0
+		recent.full = true
+	}
+}
+
+// Recent returns the most recent logged lines kept in memory, oldest first.
+func Recent() []Entry {
+	recent.Lock()
+	defer recent.Unlock()
+	var l []Entry
+	if recent.full {
+		l = append(l, recent.entries[recent.next:]...)
+	}
+	return append(l, recent.entries[:recent.next]...)
+}
@@ -0,0 +1,437 @@
+// Package moderation holds messages sent to moderated aliases by non-members,
+// until the owner of the alias approves or rejects them. It also queues messages
+// to aliases for their external members.
+//
+// When a message is held, the owner address of the alias is sent a message with a
+// link to a page on the account web interface. The link contains a random token,
+// knowledge of the token is what authorizes approving or rejecting the message.
+// Approved messages are delivered to the local members, and queued for delivery
+// to the external members. Held messages are removed when they expire.
+//
+// Message files are stored in the "moderation" directory in the data directory,
+// named after the ID of the held message.
+package moderation
+
+import (
+	"context"
+	cryptorand "crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/mox/admindb"
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/store"
+)
+
+var metricModeration = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "mox_moderation_total",
+		Help: "Messages for moderated aliases held for and removed from moderation, by action.",
+	},
+	[]string{
+		"action", // hold, approve, reject, expire
+	},
+)
+
+// Expiration is the period after which held messages are removed.
+const Expiration = 7 * 24 * time.Hour
+
+// Interval between checks for expired messages.
+const interval = time.Hour
+
+// Approvals and rejections are serialized, so a message cannot be delivered twice.
+var mutex sync.Mutex
+
+// Path returns the path of the message file of a held message.
+func Path(id int64) string {
+	return mox.DataDirPath(filepath.Join("moderation", fmt.Sprintf("%d", id)))
+}
+
+// Owner returns the account and address responsible for alias, for delivery
+// failure notifications and moderation requests. If the alias has no owner
+// address, the postmaster address of the alias domain is returned.
+func Owner(alias config.Alias) (accountName string, dest config.Destination, addr smtp.Address, rerr error) {
+	if alias.OwnerAddress != "" {
+		return alias.ParsedOwner.AccountName, alias.ParsedOwner.Destination, alias.ParsedOwner.Address, nil
+	}
+	accountName, _, _, dest, err := mox.LookupAddress("postmaster", alias.Domain, true, false)
+	if err != nil {
+		return "", config.Destination{}, smtp.Address{}, fmt.Errorf("looking up postmaster for alias domain: %v", err)
+	}
+	return accountName, dest, smtp.NewAddress("postmaster", alias.Domain), nil
+}
+
+// ForwardExternal queues the message in msgFile, preceded by prefix, for delivery
+// to the external members of alias, except for address skip, typically the
+// address in the message From header. The SMTP MAIL FROM is the owner address of
+// the alias, so delivery failures are delivered to the owner.
+func ForwardExternal(ctx context.Context, log mlog.Log, alias config.Alias, skip smtp.Address, has8bit, smtputf8 bool, size int64, messageID, subject string, prefix []byte, msgFile *os.File) error {
+	if len(alias.ExternalAddresses) == 0 {
+		return nil
+	}
+	accountName, _, owner, err := Owner(alias)
+	if err != nil {
+		return err
+	}
+	var qml []queue.Msg
+	for _, ea := range alias.ExternalAddresses {
+		if ea == skip {
+			continue
+		}
+		// Internationalized member addresses need SMTPUTF8, even if the message didn't.
+		xsmtputf8 := smtputf8 || ea.Localpart.IsInternational() || ea.Domain.Unicode != ""
+		qm := queue.MakeMsg(owner.Path(), ea.Path(), has8bit, xsmtputf8, size, messageID, prefix, nil, time.Now(), subject)
+		qml = append(qml, qm)
+	}
+	if len(qml) == 0 {
+		return nil
+	}
+	if err := queue.Add(ctx, log, accountName, msgFile, qml...); err != nil {
+		return fmt.Errorf("queueing message for external members: %v", err)
+	}
+	log.Info("message for alias queued for external members", slog.Int("count", len(qml)), slog.String("alias", alias.LocalpartStr+"@"+alias.Domain.Name()))
+	return nil
+}
+
+// Hold stores the message in r for approval by the owner of alias, setting the ID,
+// token and expiration time of h, and notifies the owner.
+func Hold(ctx context.Context, log mlog.Log, alias config.Alias, h *admindb.ModerationHeld, r io.Reader) (rerr error) {
+	buf := make([]byte, 16)
+	if _, err := cryptorand.Read(buf); err != nil {
+		return fmt.Errorf("generating token: %v", err)
+	}
+	h.Token = base64.RawURLEncoding.EncodeToString(buf)
+	if h.Received.IsZero() {
+		h.Received = time.Now()
+	}
+	h.Expires = h.Received.Add(Expiration)
+	if err := admindb.ModerationAdd(ctx, h); err != nil {
+		return fmt.Errorf("adding held message: %w", err)
+	}
+	defer func() {
+		if rerr != nil {
+			err := admindb.ModerationRemove(context.WithoutCancel(ctx), h.ID)
+			log.Check(err, "removing held message after error")
+		}
+	}()
+
+	p := Path(h.ID)
+	if err := os.MkdirAll(filepath.Dir(p), 0770); err != nil {
+		return fmt.Errorf("creating moderation directory: %w", err)
+	}
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0660)
+	if err != nil {
+		return fmt.Errorf("creating message file: %w", err)
+	}
+	defer func() {
+		if f != nil {
+			err := f.Close()
+			log.Check(err, "closing message file")
+		}
+		if rerr != nil {
+			err := os.Remove(p)
+			log.Check(err, "removing message file after error")
+		}
+	}()
+	if _, err := io.Copy(f, r); err != nil {
+		return fmt.Errorf("writing message file: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("sync message file: %w", err)
+	}
+	err = f.Close()
+	f = nil
+	if err != nil {
+		return fmt.Errorf("closing message file: %w", err)
+	}
+
+	if err := notify(log, alias, *h); err != nil {
+		return fmt.Errorf("notifying alias owner: %w", err)
+	}
+
+	metricModeration.WithLabelValues("hold").Inc()
+	log.Info("message for moderated alias held for approval", slog.Int64("id", h.ID), slog.String("alias", h.Alias), slog.String("msgfrom", h.MsgFrom))
+	return nil
+}
+
+// Get returns the held message for token, or admindb.ErrNotFound.
+func Get(ctx context.Context, token string) (admindb.ModerationHeld, error) {
+	if token == "" {
+		return admindb.ModerationHeld{}, fmt.Errorf("%w: missing token", admindb.ErrNotFound)
+	}
+	return admindb.ModerationGet(ctx, token)
+}
+
+// Approve delivers the held message for token to the current members of its
+// alias, and removes it.
+func Approve(ctx context.Context, log mlog.Log, token string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	h, err := Get(ctx, token)
+	if err != nil {
+		return err
+	}
+	addr, err := smtp.ParseAddress(h.Alias)
+	if err != nil {
+		return fmt.Errorf("parsing alias address: %v", err)
+	}
+	_, alias, _, _, err := mox.LookupAddress(addr.Localpart, addr.Domain, false, true)
+	if err == nil && alias == nil {
+		err = errors.New("not an alias")
+	}
+	if err != nil {
+		return fmt.Errorf("looking up alias: %w", err)
+	}
+
+	f, err := os.Open(Path(h.ID))
+	if err != nil {
+		return fmt.Errorf("open message file: %w", err)
+	}
+	defer func() {
+		err := f.Close()
+		log.Check(err, "closing message file")
+	}()
+	fi, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("stat message file: %w", err)
+	}
+
+	var mailFromLocalpart smtp.Localpart
+	var mailFromDomain string
+	if a, err := smtp.ParseAddress(h.MailFrom); err == nil {
+		mailFromLocalpart = a.Localpart
+		mailFromDomain = a.Domain.Name()
+	}
+	for _, aa := range alias.ParsedAddresses {
+		prefix := []byte("Delivered-To: " + aa.Address.Pack(h.SMTPUTF8) + "\r\n")
+		m := store.Message{
+			Received:       h.Received,
+			RemoteIP:       h.RemoteIP,
+			MailFromDomain: mailFromDomain,
+			Size:           int64(len(prefix)) + fi.Size(),
+			Sealed: store.MessageSealed{
+				MsgPrefix:         prefix,
+				MailFrom:          h.MailFrom,
+				MailFromLocalpart: mailFromLocalpart,
+				RcptToLocalpart:   addr.Localpart,
+				RcptToDomain:      addr.Domain.Name(),
+			},
+		}
+		if err := deliver(log, aa.AccountName, aa.Destination, &m, f); err != nil {
+			// Continue with the other members, the message can't be approved again.
+			log.Errorx("delivering approved message to alias member", err, slog.Any("member", aa.Address))
+		}
+	}
+	msgFrom, _ := smtp.ParseAddress(h.MsgFrom)
+	if err := ForwardExternal(ctx, log, *alias, msgFrom, h.Has8bit, h.SMTPUTF8, fi.Size(), h.MessageID, h.Subject, nil, f); err != nil {
+		log.Errorx("queueing approved message for external alias members", err)
+	}
+
+	if err := remove(ctx, log, h.ID); err != nil {
+		return err
+	}
+	metricModeration.WithLabelValues("approve").Inc()
+	log.Info("held message for moderated alias approved", slog.Int64("id", h.ID), slog.String("alias", h.Alias))
+	return nil
+}
+
+// Reject removes the held message for token without delivering it.
+func Reject(ctx context.Context, log mlog.Log, token string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	h, err := Get(ctx, token)
+	if err != nil {
+		return err
+	}
+	if err := remove(ctx, log, h.ID); err != nil {
+		return err
+	}
+	metricModeration.WithLabelValues("reject").Inc()
+	log.Info("held message for moderated alias rejected", slog.Int64("id", h.ID), slog.String("alias", h.Alias))
+	return nil
+}
+
+// remove removes the message file and database record of a held message.
+func remove(ctx context.Context, log mlog.Log, id int64) error {
+	if err := admindb.ModerationRemove(ctx, id); err != nil {
+		return fmt.Errorf("removing held message: %w", err)
+	}
+	err := os.Remove(Path(id))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Errorx("removing message file of held message", err, slog.Int64("id", id))
+	}
+	return nil
+}
+
+// Expire removes held messages that expired before now.
+func Expire(ctx context.Context, log mlog.Log, now time.Time) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	l, err := admindb.ModerationExpired(ctx, now)
+	if err != nil {
+		log.Errorx("listing expired held messages", err)
+		return
+	}
+	for _, h := range l {
+		if err := remove(ctx, log, h.ID); err != nil {
+			log.Errorx("removing expired held message", err, slog.Int64("id", h.ID))
+			continue
+		}
+		metricModeration.WithLabelValues("expire").Inc()
+	}
+	if len(l) > 0 {
+		log.Info("expired held messages for moderated aliases removed", slog.Int("count", len(l)))
+	}
+}
+
+// deliver delivers m to the destination of an account.
+func deliver(log mlog.Log, accountName string, dest config.Destination, m *store.Message, f *os.File) error {
+	acc, err := store.OpenAccount(log, accountName)
+	if err != nil {
+		return fmt.Errorf("open account: %v", err)
+	}
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+	acc.WithWLock(func() {
+		err = acc.DeliverDestination(log, dest, m, f)
+	})
+	return err
+}
+
+// notify delivers a message to the owner of alias about held message h.
+func notify(log mlog.Log, alias config.Alias, h admindb.ModerationHeld) error {
+	accountName, dest, owner, err := Owner(alias)
+	if err != nil {
+		return err
+	}
+
+	link := moderateURL(h.Token)
+	if link == "" {
+		link = "(no link available, the account web interface is not enabled over HTTPS)"
+	}
+	from := h.MsgFrom
+	if from == "" {
+		from = h.MailFrom
+	}
+	text := fmt.Sprintf(`Hi!
+
+A message was sent to moderated alias %s by a sender that is not a member:
+
+From: %s
+Subject: %s
+Received: %s
+
+The message was held for approval. To approve or reject the message, open:
+
+%s
+
+Anyone with the link can approve or reject the message. Held messages that are
+not approved before %s are removed.
+
+Cheers,
+mox
+`, h.Alias, from, h.Subject, h.Received.Format("2006-01-02 15:04"), link, h.Expires.Format("2006-01-02 15:04"))
+
+	f, err := store.CreateMessageTemp(log, "moderation-notify")
+	if err != nil {
+		return fmt.Errorf("creating temporary message file: %v", err)
+	}
+	defer store.CloseRemoveTempFile(log, f, "message for moderation request")
+
+	subject := fmt.Sprintf("mox: message for %s held for approval", h.Alias)
+	n, err := fmt.Fprintf(f, "Date: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: 8-bit\r\n\r\n%s", time.Now().Format(message.RFC5322Z), subject, strings.ReplaceAll(text, "\n", "\r\n"))
+	if err != nil {
+		return fmt.Errorf("writing temporary message file: %v", err)
+	}
+	m := store.Message{
+		Received: time.Now(),
+		Size:     int64(n),
+		Sealed:   store.MessageSealed{RcptToLocalpart: owner.Localpart, RcptToDomain: owner.Domain.Name()},
+	}
+	return deliver(log, accountName, dest, &m, f)
+}
+
+// moderateURL returns the URL to the page for approving or rejecting the held
+// message with token, on the first listener with the account web interface
+// enabled over HTTPS. Empty if there is none.
+func moderateURL(token string) string {
+	var names []string
+	for name := range mox.Conf.Static.Listeners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		l := mox.Conf.Static.Listeners[name]
+		if !l.AccountHTTPS.Enabled {
+			continue
+		}
+		host := mox.Conf.Static.HostnameDomain
+		if !l.HostnameDomain.IsZero() {
+			host = l.HostnameDomain
+		}
+		path := l.AccountHTTPS.Path
+		if path == "" {
+			path = "/"
+		}
+		return "https://" + host.ASCII + path + "moderate?token=" + url.QueryEscape(token)
+	}
+	return ""
+}
+
+// Start periodically removes expired held messages.
+func Start() {
+	log := mlog.New("moderation", nil)
+
+	go func() {
+		timer := time.NewTimer(5 * time.Minute)
+		defer timer.Stop()
+		for {
+			select {
+			case <-mox.Shutdown.Done():
+				return
+			case <-timer.C:
+			}
+
+			run(log.WithCid(mox.Cid()))
+			timer.Reset(interval)
+		}
+	}()
+}
+
+func run(log mlog.Log) {
+	defer func() {
+		x := recover()
+		if x != nil {
+			log.Error("recover from panic", slog.Any("panic", x))
+			debug.PrintStack()
+			metrics.PanicInc(metrics.Moderation)
+		}
+	}()
+
+	Expire(mox.Shutdown, log, time.Now())
+}
@@ -272,8 +272,11 @@ func MakeDomainConfig(ctx context.Context, domain, hostname dns.Domain, accountN
 
 	if withMTASTS {
 		confDomain.MTASTS = &config.MTASTS{
-			PolicyID: time.Now().UTC().Format("20060102T150405"),
-			Mode:     mtasts.ModeEnforce,
+			PolicyID: MTASTSPolicyID(time.Now()),
+			// New domains start in testing mode, and are promoted to enforce after two weeks
+			// without failures in TLS reports.
+			Mode:         mtasts.ModeTesting,
+			EnforceAfter: 14 * 24 * time.Hour,
 			// We start out with 24 hour, and warn in the admin interface that users should
 			// increase it to weeks once the setup works.
 			MaxAge: 24 * time.Hour,
@@ -287,6 +290,12 @@ func MakeDomainConfig(ctx context.Context, domain, hostname dns.Domain, accountN
 	return confDomain, rpaths, nil
 }
 
+// MTASTSPolicyID returns a policy ID for an MTA-STS policy based on t, e.g.
+// 20060102T150405.
+func MTASTSPolicyID(t time.Time) string {
+	return t.UTC().Format("20060102T150405")
+}
+
 // DKIMAdd adds a DKIM selector for a domain, generating a key and writing it to disk.
 func DKIMAdd(ctx context.Context, domain, selector dns.Domain, algorithm, hash string, headerRelaxed, bodyRelaxed, seal bool, headers []string, lifetime time.Duration) (rerr error) {
 	log := pkglog.WithContext(ctx)
@@ -448,7 +457,8 @@ func DKIMRemove(ctx context.Context, domain, selector dns.Domain) (rerr error) {
 // DomainAdd adds the domain to the domains config, rewriting domains.conf and
 // marking it loaded.
 //
-// accountName is used for DMARC/TLS report and potentially for the postmaster address.
+// accountName is used for DMARC/TLS report and potentially for the role addresses
+// (postmaster, abuse, security).
 // If the account does not exist, it is created with localpart. Localpart must be
 // set only if the account does not yet exist.
 func DomainAdd(ctx context.Context, domain dns.Domain, accountName string, localpart smtp.Localpart) (rerr error) {
@@ -512,8 +522,9 @@ func DomainAdd(ctx context.Context, domain dns.Domain, accountName string, local
 		for k, v := range nacc.Destinations {
 			nd[k] = v
 		}
-		pmaddr := smtp.Address{Localpart: "postmaster", Domain: domain}
-		nd[pmaddr.String()] = config.Destination{}
+		for _, lp := range RoleLocalparts {
+			nd[smtp.NewAddress(lp, domain).String()] = config.Destination{}
+		}
 		nacc.Destinations = nd
 		nc.Accounts[accountName] = nacc
 	}
@@ -855,6 +866,17 @@ func DomainRecords(domConf config.Domain, domain dns.Domain, hasDNSSEC bool, cer
 		)
 	}
 
+	for _, l := range Conf.Static.Listeners {
+		if l.WKDHTTPS.Enabled {
+			records = append(records,
+				"; For OpenPGP Web Key Directory (WKD), for looking up public keys of users.",
+				fmt.Sprintf(`openpgpkey.%s.         CNAME %s.`, d, h),
+				"",
+			)
+			break
+		}
+	}
+
 	if domConf.TLSRPT != nil {
 		uri := url.URL{
 			Scheme: "mailto",
@@ -994,12 +1016,19 @@ func AccountAdd(ctx context.Context, account, address string) (rerr error) {
 	return nil
 }
 
+// AccountRemoved is called after an account was removed from the configuration,
+// without holding the config lock. Set by package store to remove links to the
+// account from other accounts.
+var AccountRemoved func(log mlog.Log, account string)
+
 // AccountRemove removes an account and reloads the configuration.
 func AccountRemove(ctx context.Context, account string) (rerr error) {
 	log := pkglog.WithContext(ctx)
 	defer func() {
 		if rerr != nil {
 			log.Errorx("adding account", rerr, slog.String("account", account))
+		} else if AccountRemoved != nil {
+			AccountRemoved(log, account)
 		}
 	}()
 
@@ -1229,6 +1258,8 @@ func AliasUpdate(ctx context.Context, addr smtp.Address, alias config.Alias) err
 		a.PostPublic = alias.PostPublic
 		a.ListMembers = alias.ListMembers
 		a.AllowMsgFrom = alias.AllowMsgFrom
+		a.OwnerAddress = alias.OwnerAddress
+		a.Moderated = alias.Moderated
 		d.Aliases = maps.Clone(d.Aliases)
 		d.Aliases[addr.Localpart.String()] = a
 		return nil
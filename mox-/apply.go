@@ -0,0 +1,249 @@
+package mox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/mjl-/sconf"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/moxio"
+)
+
+// ConfigChange is a difference between the running dynamic config and a desired
+// dynamic config.
+type ConfigChange struct {
+	Action string // "add", "change" or "remove".
+	Kind   string // "domain", "alias", "account" or "address". For other fields of domains.conf the field name, e.g. "WebHandlers".
+	Name   string // Domain, alias address, account or address. Empty for other fields.
+}
+
+func (c ConfigChange) String() string {
+	s := c.Action + " " + c.Kind
+	if c.Name != "" {
+		s += " " + c.Name
+	}
+	return s
+}
+
+// ConfigApply replaces the dynamic config with a complete desired domains.conf,
+// in sconf format, or as JSON object as returned by the admin web API, and returns
+// the changes compared to the running config. Applying the same config again
+// results in no changes. If dryRun is set, the desired config is only validated
+// and compared.
+//
+// The desired config is written as is, so it can have comments, includes,
+// environment variables and file references. It must be valid, otherwise
+// ErrConfig is returned and nothing changes. The file is replaced atomically, and
+// takes effect immediately. DKIM private keys referenced by the config must
+// already exist.
+func ConfigApply(ctx context.Context, log mlog.Log, desired []byte, dryRun bool) ([]ConfigChange, error) {
+	if b := bytes.TrimSpace(desired); len(b) > 0 && b[0] == '{' {
+		var c config.Dynamic
+		if err := json.Unmarshal(desired, &c); err != nil {
+			return nil, fmt.Errorf("%w: parsing json: %v", ErrConfig, err)
+		}
+		var buf bytes.Buffer
+		if err := sconf.Write(&buf, c); err != nil {
+			return nil, fmt.Errorf("writing config in sconf format: %v", err)
+		}
+		desired = buf.Bytes()
+	}
+
+	Conf.dynamicMutex.Lock()
+	defer Conf.dynamicMutex.Unlock()
+
+	// Written next to domains.conf, so relative paths in includes and file references
+	// are the same, and so it can be renamed into place.
+	f, err := os.CreateTemp(filepath.Dir(ConfigDynamicPath), "domains.conf.apply-*")
+	if err != nil {
+		return nil, fmt.Errorf("creating temporary file: %v", err)
+	}
+	tmpPath := f.Name()
+	defer func() {
+		if f != nil {
+			err := f.Close()
+			log.Check(err, "closing temporary config file")
+		}
+		if tmpPath != "" {
+			err := os.Remove(tmpPath)
+			log.Check(err, "removing temporary config file")
+		}
+	}()
+	if _, err := f.Write(desired); err != nil {
+		return nil, fmt.Errorf("writing temporary file: %v", err)
+	}
+
+	cf, err := readConfigFile(tmpPath)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrConfig, err)
+	}
+	buf, _, err := migrateDynamic(cf.buf)
+	if err != nil {
+		return nil, fmt.Errorf("%w: migrating config: %v", ErrConfig, err)
+	}
+	var nc config.Dynamic
+	if err := sconf.Parse(bytes.NewReader(buf), &nc); err != nil {
+		return nil, fmt.Errorf("%w: parsing config: %v", ErrConfig, err)
+	}
+	accDests, aliases, errs := prepareDynamicConfig(ctx, log, ConfigDynamicPath, Conf.Static, &nc)
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("%w: %w", ErrConfig, errors.Join(errs...))
+	}
+
+	changes := dynamicChanges(Conf.Dynamic, nc)
+	if dryRun {
+		return changes, nil
+	}
+
+	if err := f.Chmod(0660); err != nil {
+		return nil, fmt.Errorf("setting permissions of temporary file: %v", err)
+	}
+	if err := f.Sync(); err != nil {
+		return nil, fmt.Errorf("sync temporary file: %v", err)
+	}
+	err = f.Close()
+	f = nil
+	if err != nil {
+		return nil, fmt.Errorf("close temporary file: %v", err)
+	}
+	if err := os.Rename(tmpPath, ConfigDynamicPath); err != nil {
+		return nil, fmt.Errorf("replacing domains.conf: %v", err)
+	}
+	tmpPath = ""
+	if err := moxio.SyncDir(log, filepath.Dir(ConfigDynamicPath)); err != nil {
+		return nil, fmt.Errorf("sync dir of domains.conf after replacing: %v", err)
+	}
+	fi, err := os.Stat(ConfigDynamicPath)
+	if err != nil {
+		return nil, fmt.Errorf("stat after replacing domains.conf: %v", err)
+	}
+
+	Conf.dynamicMtime = fi.ModTime()
+	Conf.DynamicLastCheck = time.Now()
+	Conf.Dynamic = nc
+	Conf.accountDestinations = accDests
+	Conf.aliases = aliases
+
+	Conf.allowACMEHosts(log, true)
+
+	log.Info("config applied", slog.Int("changes", len(changes)))
+	return changes, nil
+}
+
+// dynamicChanges returns the changes from config a to b. Values are compared in
+// sconf format, without fields derived during parsing. Changes to addresses and
+// aliases are listed separately from their account or domain.
+func dynamicChanges(a, b config.Dynamic) []ConfigChange {
+	var l []ConfigChange
+	add := func(action, kind, name string) {
+		l = append(l, ConfigChange{action, kind, name})
+	}
+
+	// compare adds changes for the keys in maps a and b, calling fn for keys in
+	// both. fn returns whether the values differ.
+	compare := func(kind string, a, b any, fn func(k string) bool) {
+		av := reflect.ValueOf(a)
+		bv := reflect.ValueOf(b)
+		var keys []string
+		for _, k := range av.MapKeys() {
+			keys = append(keys, k.String())
+		}
+		for _, k := range bv.MapKeys() {
+			if !av.MapIndex(k).IsValid() {
+				keys = append(keys, k.String())
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			kv := reflect.ValueOf(k)
+			if !bv.MapIndex(kv).IsValid() {
+				add("remove", kind, k)
+			} else if !av.MapIndex(kv).IsValid() {
+				add("add", kind, k)
+			} else if fn(k) {
+				add("change", kind, k)
+			}
+		}
+	}
+
+	compare("domain", a.Domains, b.Domains, func(k string) bool {
+		da, db := a.Domains[k], b.Domains[k]
+		da.Aliases, db.Aliases = nil, nil
+		return sconfText(da) != sconfText(db)
+	})
+	aliasesA := map[string]config.Alias{}
+	aliasesB := map[string]config.Alias{}
+	for d, dc := range a.Domains {
+		for lp, alias := range dc.Aliases {
+			aliasesA[lp+"@"+d] = alias
+		}
+	}
+	for d, dc := range b.Domains {
+		for lp, alias := range dc.Aliases {
+			aliasesB[lp+"@"+d] = alias
+		}
+	}
+	compare("alias", aliasesA, aliasesB, func(k string) bool {
+		return sconfText(aliasesA[k]) != sconfText(aliasesB[k])
+	})
+
+	compare("account", a.Accounts, b.Accounts, func(k string) bool {
+		aa, ab := a.Accounts[k], b.Accounts[k]
+		aa.Destinations, ab.Destinations = nil, nil
+		return sconfText(aa) != sconfText(ab)
+	})
+	// Addresses that move to another account are listed as changed.
+	addrsA := map[string]string{}
+	addrsB := map[string]string{}
+	for name, acc := range a.Accounts {
+		for addr, dest := range acc.Destinations {
+			addrsA[addr] = name + "\n" + sconfText(dest)
+		}
+	}
+	for name, acc := range b.Accounts {
+		for addr, dest := range acc.Destinations {
+			addrsB[addr] = name + "\n" + sconfText(dest)
+		}
+	}
+	compare("address", addrsA, addrsB, func(k string) bool {
+		return addrsA[k] != addrsB[k]
+	})
+
+	// Other fields, compared as a whole.
+	av := reflect.ValueOf(a)
+	bv := reflect.ValueOf(b)
+	t := av.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Name == "Domains" || f.Name == "Accounts" || f.Tag.Get("sconf") == "-" {
+			continue
+		}
+		var xa, xb config.Dynamic
+		reflect.ValueOf(&xa).Elem().Field(i).Set(av.Field(i))
+		reflect.ValueOf(&xb).Elem().Field(i).Set(bv.Field(i))
+		if sconfText(xa) != sconfText(xb) {
+			add("change", f.Name, "")
+		}
+	}
+	return l
+}
+
+// sconfText returns v in sconf format, for comparing config values.
+func sconfText(v any) string {
+	var b bytes.Buffer
+	if err := sconf.Write(&b, v); err != nil {
+		return fmt.Sprintf("(error: %v)", err)
+	}
+	return b.String()
+}
@@ -0,0 +1,132 @@
+package mox
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestConfigApply(t *testing.T) {
+	ctx := context.Background()
+	log := pkglog
+
+	dir := t.TempDir()
+	ConfigStaticPath = filepath.Join(dir, "mox.conf")
+	ConfigDynamicPath = filepath.Join(dir, "domains.conf")
+	defer func() {
+		ConfigStaticPath = ""
+		ConfigDynamicPath = ""
+	}()
+
+	const static = `DataDir: data
+User: 1000
+LogLevel: info
+Hostname: mox.example
+Postmaster:
+	Account: mjl
+	Mailbox: postmaster
+Listeners:
+	local: nil
+`
+	const dynamic = `Domains:
+	mox.example: nil
+Accounts:
+	mjl:
+		Domain: mox.example
+		Destinations:
+			mjl@mox.example: nil
+`
+	writeFile := func(p, s string) {
+		t.Helper()
+		err := os.WriteFile(p, []byte(s), 0660)
+		if err != nil {
+			t.Fatalf("write file: %v", err)
+		}
+	}
+	writeFile(ConfigStaticPath, static)
+	writeFile(ConfigDynamicPath, dynamic)
+	if errs := LoadConfig(ctx, log, false, false); len(errs) > 0 {
+		t.Fatalf("load config: %v", errs)
+	}
+
+	check := func(desired string, dryRun bool, exp []ConfigChange) {
+		t.Helper()
+		changes, err := ConfigApply(ctx, log, []byte(desired), dryRun)
+		if err != nil {
+			t.Fatalf("apply: %v", err)
+		}
+		if !reflect.DeepEqual(changes, exp) {
+			t.Fatalf("apply: got changes %v, expected %v", changes, exp)
+		}
+	}
+
+	// Same config, no changes.
+	check(dynamic, false, nil)
+
+	const desired = `# Managed elsewhere.
+Domains:
+	mox.example:
+		Aliases:
+			all:
+				Addresses:
+					- mjl@mox.example
+					- other@mox.example
+	other.example: nil
+Accounts:
+	mjl:
+		Domain: mox.example
+		Destinations:
+			mjl@mox.example: nil
+			mjl@other.example: nil
+	other:
+		Domain: mox.example
+		Destinations:
+			other@mox.example: nil
+`
+	exp := []ConfigChange{
+		{"add", "domain", "other.example"},
+		{"add", "alias", "all@mox.example"},
+		{"add", "account", "other"},
+		{"add", "address", "mjl@other.example"},
+		{"add", "address", "other@mox.example"},
+	}
+	check(desired, true, exp)
+	if _, ok := Conf.Account("other"); ok {
+		t.Fatalf("dry run changed config")
+	}
+
+	check(desired, false, exp)
+	if _, ok := Conf.Account("other"); !ok {
+		t.Fatalf("account not added")
+	}
+	if buf, err := os.ReadFile(ConfigDynamicPath); err != nil || string(buf) != desired {
+		t.Fatalf("domains.conf not replaced with desired config: %v", err)
+	}
+
+	// Applying again is a no-op.
+	check(desired, false, nil)
+
+	// JSON, removing what was added.
+	check(`{"Domains": {"mox.example": {}}, "Accounts": {"mjl": {"Domain": "mox.example", "Destinations": {"mjl@mox.example": {}}}}}`, false, []ConfigChange{
+		{"remove", "domain", "other.example"},
+		{"remove", "alias", "all@mox.example"},
+		{"remove", "account", "other"},
+		{"remove", "address", "mjl@other.example"},
+		{"remove", "address", "other@mox.example"},
+	})
+
+	// Invalid config is not applied.
+	_, err := ConfigApply(ctx, log, []byte("Domains:\n\tmox.example: nil\nAccounts:\n\tother:\n\t\tDomain: mox.example\n"), false)
+	if err == nil || !errors.Is(err, ErrConfig) {
+		t.Fatalf("apply invalid config: got err %v, expected config error", err)
+	}
+	if _, ok := Conf.Account("mjl"); !ok {
+		t.Fatalf("invalid config without postmaster account was applied")
+	}
+	if l, err := filepath.Glob(filepath.Join(dir, "domains.conf.apply-*")); err != nil || len(l) != 0 {
+		t.Fatalf("temporary files left behind: %v %v", l, err)
+	}
+}
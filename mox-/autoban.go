@@ -0,0 +1,347 @@
+package mox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/mox/mlog"
+)
+
+var (
+	metricAutoBan = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mox_autoban_total",
+			Help: "Automatic bans of remote networks.",
+		},
+		[]string{
+			"reason", // auth, protocol
+		},
+	)
+	metricAutoBanRefused = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "mox_autoban_refused_total",
+			Help: "Connections and logins refused due to an automatic ban.",
+		},
+	)
+)
+
+// Kinds of failures counted towards automatic bans.
+const (
+	AutoBanAuth     = "auth"     // Failed authentication attempt.
+	AutoBanProtocol = "protocol" // Protocol abuse, e.g. unknown commands.
+)
+
+var ErrAutoBanNotFound = errors.New("no ban for network")
+
+// AutoBanEntry is a banned network.
+type AutoBanEntry struct {
+	Network  string // E.g. 198.51.100.1/32 or 2001:db8:1:2::/64.
+	Reason   string // "auth" or "protocol".
+	Failures int    // Number of failures that caused the ban.
+	Start    time.Time
+	End      time.Time
+	Refused  int64 // Connections and logins refused during the ban.
+}
+
+type autoBanState struct {
+	AutoBanEntry
+	timer *time.Timer
+}
+
+// Maximum number of networks with failures tracked. Protects against unbounded
+// memory use by attackers that rotate through many networks, e.g. IPv6 /64s.
+const autoBanMaxTracked = 100 * 1000
+
+var autoBan = struct {
+	sync.Mutex
+	failures  map[string][]time.Time // Keyed by kind and network, e.g. "auth 198.51.100.1/32".
+	bans      map[string]*autoBanState
+	sweepOnce sync.Once
+}{
+	failures: map[string][]time.Time{},
+	bans:     map[string]*autoBanState{},
+}
+
+// autoBanWindow returns the configured window in which failures are counted.
+func autoBanWindow() time.Duration {
+	if ab := Conf.Static.AutoBan; ab != nil && ab.Window != 0 {
+		return ab.Window
+	}
+	return 10 * time.Minute
+}
+
+// AutoBanSMTPDelivery returns whether SMTP for incoming delivery (port 25) is
+// subject to automatic bans.
+func AutoBanSMTPDelivery() bool {
+	ab := Conf.Static.AutoBan
+	return ab != nil && ab.SMTPDelivery
+}
+
+// autoBanPrune removes failures outside the window from l.
+func autoBanPrune(l []time.Time, now time.Time, window time.Duration) []time.Time {
+	for len(l) > 0 && now.Sub(l[0]) > window {
+		l = l[1:]
+	}
+	return l
+}
+
+// autoBanSweep removes networks without failures in the window. Must be called
+// with autoBan locked.
+func autoBanSweep(now time.Time, window time.Duration) {
+	for k, l := range autoBan.failures {
+		if l = autoBanPrune(l, now, window); len(l) == 0 {
+			delete(autoBan.failures, k)
+		} else {
+			autoBan.failures[k] = l
+		}
+	}
+}
+
+// autoBanSweeper periodically removes networks without recent failures.
+func autoBanSweeper() {
+	for {
+		time.Sleep(time.Minute)
+		autoBan.Lock()
+		autoBanSweep(time.Now(), autoBanWindow())
+		autoBan.Unlock()
+	}
+}
+
+// autoBanNetwork returns the network to count failures for and ban for ip, and
+// false if automatic bans are not enabled or ip is exempt.
+func autoBanNetwork(ip net.IP) (string, bool) {
+	ab := Conf.Static.AutoBan
+	if ab == nil || ip == nil || ip.IsLoopback() {
+		return "", false
+	}
+	for _, ipnet := range ab.ExemptNets {
+		if ipnet.Contains(ip) {
+			return "", false
+		}
+	}
+	var ipnet net.IPNet
+	if ip4 := ip.To4(); ip4 != nil {
+		prefix := ab.IPv4Prefix
+		if prefix == 0 {
+			prefix = 32
+		}
+		ipnet.Mask = net.CIDRMask(prefix, 32)
+		ipnet.IP = ip4.Mask(ipnet.Mask)
+	} else {
+		prefix := ab.IPv6Prefix
+		if prefix == 0 {
+			prefix = 64
+		}
+		ipnet.Mask = net.CIDRMask(prefix, 128)
+		ipnet.IP = ip.Mask(ipnet.Mask)
+	}
+	return ipnet.String(), true
+}
+
+// AutoBanFailure registers a failure of kind AutoBanAuth or AutoBanProtocol for
+// remote ip, and bans the network of ip when it reaches the configured number of
+// failures within the window.
+func AutoBanFailure(log mlog.Log, ip net.IP, kind string) {
+	network, ok := autoBanNetwork(ip)
+	if !ok {
+		return
+	}
+	ab := Conf.Static.AutoBan
+	limit := ab.AuthFailures
+	if kind == AutoBanProtocol {
+		limit = ab.ProtocolErrors
+	}
+	if limit < 0 {
+		return
+	} else if limit == 0 {
+		limit = 10
+		if kind == AutoBanProtocol {
+			limit = 20
+		}
+	}
+	window := autoBanWindow()
+	duration := ab.Duration
+	if duration == 0 {
+		duration = time.Hour
+	}
+
+	now := time.Now()
+	key := kind + " " + network
+
+	autoBan.sweepOnce.Do(func() {
+		go autoBanSweeper()
+	})
+
+	autoBan.Lock()
+	defer autoBan.Unlock()
+
+	if _, ok := autoBan.bans[network]; ok {
+		return
+	}
+
+	prev, ok := autoBan.failures[key]
+	if !ok && len(autoBan.failures) >= autoBanMaxTracked {
+		autoBanSweep(now, window)
+		// Still too many, forget an arbitrary network.
+		for k := range autoBan.failures {
+			if len(autoBan.failures) < autoBanMaxTracked {
+				break
+			}
+			delete(autoBan.failures, k)
+		}
+	}
+	l := append(autoBanPrune(prev, now, window), now)
+	if len(l) < limit {
+		autoBan.failures[key] = l
+		return
+	}
+	delete(autoBan.failures, AutoBanAuth+" "+network)
+	delete(autoBan.failures, AutoBanProtocol+" "+network)
+
+	st := &autoBanState{
+		AutoBanEntry: AutoBanEntry{
+			Network:  network,
+			Reason:   kind,
+			Failures: len(l),
+			Start:    now,
+			End:      now.Add(duration),
+		},
+	}
+	st.timer = time.AfterFunc(duration, func() {
+		autoBan.Lock()
+		defer autoBan.Unlock()
+		if autoBan.bans[network] == st {
+			delete(autoBan.bans, network)
+			autoBanCommand(pkglog, "unban", network, 0)
+		}
+	})
+	autoBan.bans[network] = st
+	metricAutoBan.WithLabelValues(kind).Inc()
+	log.Info("automatically banning network",
+		slog.String("network", network),
+		slog.String("reason", kind),
+		slog.Int("failures", len(l)),
+		slog.Duration("duration", duration))
+	autoBanCommand(log, "ban", network, duration)
+}
+
+// AutoBanned returns whether ip is in a banned network. Callers must refuse the
+// connection or login if so, the refusal is counted.
+func AutoBanned(ip net.IP) bool {
+	network, ok := autoBanNetwork(ip)
+	if !ok {
+		return false
+	}
+	autoBan.Lock()
+	defer autoBan.Unlock()
+	st, ok := autoBan.bans[network]
+	if !ok {
+		return false
+	}
+	st.Refused++
+	metricAutoBanRefused.Inc()
+	return true
+}
+
+// AutoBans returns the current bans, most recent first.
+func AutoBans() []AutoBanEntry {
+	autoBan.Lock()
+	defer autoBan.Unlock()
+	l := make([]AutoBanEntry, 0, len(autoBan.bans))
+	for _, st := range autoBan.bans {
+		l = append(l, st.AutoBanEntry)
+	}
+	sort.Slice(l, func(i, j int) bool {
+		return l[i].Start.After(l[j].Start)
+	})
+	return l
+}
+
+// AutoBanRemove removes the ban for network, as returned by AutoBans.
+func AutoBanRemove(log mlog.Log, network string) error {
+	autoBan.Lock()
+	defer autoBan.Unlock()
+	st, ok := autoBan.bans[network]
+	if !ok {
+		return ErrAutoBanNotFound
+	}
+	st.timer.Stop()
+	delete(autoBan.bans, network)
+	log.Info("removed automatic ban", slog.String("network", network))
+	autoBanCommand(log, "unban", network, 0)
+	return nil
+}
+
+// autoBanCommand runs the configured command in the background, if any.
+func autoBanCommand(log mlog.Log, action, network string, duration time.Duration) {
+	ab := Conf.Static.AutoBan
+	if ab == nil || ab.Command == "" {
+		return
+	}
+	command := ab.Command
+	go func() {
+		ctx, cancel := context.WithTimeout(Context, time.Minute)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, "sh", "-c", command)
+		cmd.Env = append(os.Environ(),
+			"AUTOBAN_ACTION="+action,
+			"AUTOBAN_NETWORK="+network,
+			fmt.Sprintf("AUTOBAN_DURATION=%d", duration/time.Second),
+		)
+		buf, err := cmd.CombinedOutput()
+		if err != nil {
+			log.Errorx("running autoban command", err,
+				slog.String("action", action),
+				slog.String("network", network),
+				slog.String("output", strings.TrimSpace(string(buf))))
+		}
+	}()
+}
+
+// autoBanListener closes accepted connections from banned networks.
+type autoBanListener struct {
+	net.Listener
+}
+
+func (l autoBanListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if a, ok := conn.RemoteAddr().(*net.TCPAddr); ok && AutoBanned(a.IP) {
+			conn.Close()
+			continue
+		}
+		return conn, nil
+	}
+}
+
+// autoBanPacketConn drops packets from banned networks.
+type autoBanPacketConn struct {
+	net.PacketConn
+}
+
+func (c autoBanPacketConn) ReadFrom(buf []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(buf)
+		if err == nil {
+			if a, ok := addr.(*net.UDPAddr); ok && AutoBanned(a.IP) {
+				continue
+			}
+		}
+		return n, addr, err
+	}
+}
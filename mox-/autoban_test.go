@@ -0,0 +1,74 @@
+package mox
+
+import (
+	"net"
+	"testing"
+
+	"github.com/mjl-/mox/config"
+)
+
+func TestAutoBan(t *testing.T) {
+	log := pkglog
+
+	_, exempt, err := net.ParseCIDR("192.0.2.0/24")
+	if err != nil {
+		t.Fatalf("parse cidr: %v", err)
+	}
+	Conf.Static.AutoBan = &config.AutoBan{
+		AuthFailures:   3,
+		ProtocolErrors: -1,
+		IPv4Prefix:     24,
+		ExemptNets:     []net.IPNet{*exempt},
+	}
+	defer func() {
+		Conf.Static.AutoBan = nil
+	}()
+
+	ip := net.ParseIP("198.51.100.1")
+	other := net.ParseIP("198.51.100.2") // Same /24.
+
+	AutoBanFailure(log, ip, AutoBanAuth)
+	AutoBanFailure(log, other, AutoBanAuth)
+	if AutoBanned(ip) {
+		t.Fatalf("banned before reaching limit")
+	}
+	// Protocol errors are disabled.
+	for i := 0; i < 10; i++ {
+		AutoBanFailure(log, ip, AutoBanProtocol)
+	}
+	if AutoBanned(ip) {
+		t.Fatalf("banned for disabled protocol errors")
+	}
+	AutoBanFailure(log, ip, AutoBanAuth)
+	if !AutoBanned(other) {
+		t.Fatalf("network not banned after reaching limit")
+	}
+	if AutoBanned(net.ParseIP("198.51.101.1")) {
+		t.Fatalf("other network banned")
+	}
+
+	bans := AutoBans()
+	if len(bans) != 1 || bans[0].Network != "198.51.100.0/24" || bans[0].Reason != AutoBanAuth || bans[0].Failures != 3 || bans[0].Refused != 1 {
+		t.Fatalf("unexpected bans %#v", bans)
+	}
+
+	// Exempt networks and loopback are never banned.
+	for _, xip := range []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("127.0.0.1")} {
+		for i := 0; i < 3; i++ {
+			AutoBanFailure(log, xip, AutoBanAuth)
+		}
+		if AutoBanned(xip) {
+			t.Fatalf("exempt ip %s banned", xip)
+		}
+	}
+
+	if err := AutoBanRemove(log, "198.51.100.0/24"); err != nil {
+		t.Fatalf("remove ban: %v", err)
+	}
+	if AutoBanned(ip) {
+		t.Fatalf("still banned after removing ban")
+	}
+	if err := AutoBanRemove(log, "198.51.100.0/24"); err != ErrAutoBanNotFound {
+		t.Fatalf("removing absent ban, got err %v, expected ErrAutoBanNotFound", err)
+	}
+}
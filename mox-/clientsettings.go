@@ -0,0 +1,207 @@
+package mox
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+
+	"golang.org/x/exp/maps"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/smtp"
+)
+
+// ClientSettings holds the settings for configuring email, calendar and contacts
+// applications for an email address, generated from the listener configuration,
+// with setup instructions for common applications.
+type ClientSettings struct {
+	Address    string // Email address, also used as login name.
+	IMAP       ClientServer
+	Submission ClientServer
+
+	// URLs of web services and autoconfiguration. Empty if not enabled.
+	Account      string
+	Webmail      string
+	WebAPI       string
+	DAV          string // CalDAV and CardDAV.
+	Autoconfig   string // Thunderbird autoconfig.
+	Autodiscover string // Microsoft autodiscover.
+	MobileConfig string // Apple device management profile.
+
+	Clients []ClientInstructions
+}
+
+// ClientServer is a server that email applications connect to.
+type ClientServer struct {
+	Host     string // ASCII hostname.
+	Port     int
+	Security string // "tls" for TLS from the start of the connection, "starttls", or "none".
+}
+
+// ClientInstructions are setup steps for an application.
+type ClientInstructions struct {
+	Client string
+	Steps  []string
+}
+
+// clientListenerNames returns the listener names in the order used for finding
+// client settings: the public listener first, then the others in sorted order.
+func clientListenerNames() []string {
+	names := maps.Keys(Conf.Static.Listeners)
+	sort.Slice(names, func(i, j int) bool {
+		if names[i] == "public" || names[j] == "public" {
+			return names[i] == "public"
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+// ClientSettingsAddress returns client settings for an email address at a
+// configured domain.
+func ClientSettingsAddress(addr smtp.Address) (ClientSettings, error) {
+	domConf, ok := Conf.Domain(addr.Domain)
+	if !ok {
+		return ClientSettings{}, fmt.Errorf("%w: unknown domain", ErrRequest)
+	}
+	cc, err := ClientConfigDomain(addr.Domain)
+	if err != nil {
+		return ClientSettings{}, err
+	}
+
+	security := func(mode TLSMode) string {
+		switch mode {
+		case TLSModeImmediate:
+			return "tls"
+		case TLSModeSTARTTLS:
+			return "starttls"
+		}
+		return "none"
+	}
+	cs := ClientSettings{
+		Address:    addr.String(),
+		IMAP:       ClientServer{cc.IMAP.Host.ASCII, cc.IMAP.Port, security(cc.IMAP.TLSMode)},
+		Submission: ClientServer{cc.Submission.Host.ASCII, cc.Submission.Port, security(cc.Submission.TLSMode)},
+	}
+
+	host := func(l config.Listener) dns.Domain {
+		if domConf.ClientSettingsDomain != "" {
+			return domConf.ClientSettingsDNSDomain
+		}
+		if l.Hostname != "" {
+			return l.HostnameDomain
+		}
+		return Conf.Static.HostnameDomain
+	}
+	mkURL := func(https bool, host string, port int, path string, query url.Values) string {
+		u := url.URL{Scheme: "http", Host: host, Path: path, RawQuery: query.Encode()}
+		defPort := 80
+		if https {
+			u.Scheme = "https"
+			defPort = 443
+		}
+		if port != defPort {
+			u.Host = fmt.Sprintf("%s:%d", host, port)
+		}
+		return u.String()
+	}
+	// webURL returns the URL for the first listener with the web service enabled,
+	// preferring HTTPS.
+	webURL := func(services func(l config.Listener) (https, http config.WebService), defPath string) string {
+		for _, name := range clientListenerNames() {
+			l := Conf.Static.Listeners[name]
+			https, http := services(l)
+			path := func(ws config.WebService) string {
+				if ws.Path != "" {
+					return ws.Path
+				}
+				return defPath
+			}
+			if https.Enabled && l.TLS != nil {
+				return mkURL(true, host(l).ASCII, config.Port(https.Port, 443), path(https), nil)
+			}
+			if http.Enabled {
+				return mkURL(false, host(l).ASCII, config.Port(http.Port, 80), path(http), nil)
+			}
+		}
+		return ""
+	}
+	cs.Account = webURL(func(l config.Listener) (config.WebService, config.WebService) { return l.AccountHTTPS, l.AccountHTTP }, "/")
+	cs.Webmail = webURL(func(l config.Listener) (config.WebService, config.WebService) { return l.WebmailHTTPS, l.WebmailHTTP }, "/webmail/")
+	cs.WebAPI = webURL(func(l config.Listener) (config.WebService, config.WebService) { return l.WebAPIHTTPS, l.WebAPIHTTP }, "/webapi/")
+	cs.DAV = webURL(func(l config.Listener) (config.WebService, config.WebService) { return l.DAVHTTPS, l.DAVHTTP }, "/dav/")
+
+	for _, name := range clientListenerNames() {
+		l := Conf.Static.Listeners[name]
+		if !l.AutoconfigHTTPS.Enabled {
+			continue
+		}
+		https := !l.AutoconfigHTTPS.NonTLS
+		port := config.Port(l.AutoconfigHTTPS.Port, 443)
+		cs.Autoconfig = mkURL(https, "autoconfig."+addr.Domain.ASCII, port, "/mail/config-v1.1.xml", url.Values{"emailaddress": {cs.Address}})
+		cs.Autodiscover = mkURL(https, "autodiscover."+addr.Domain.ASCII, port, "/autodiscover/autodiscover.xml", nil)
+		cs.MobileConfig = mkURL(https, "autoconfig."+addr.Domain.ASCII, port, "/profile.mobileconfig", url.Values{"addresses": {cs.Address}})
+		break
+	}
+
+	cs.Clients = clientInstructions(cs)
+	return cs, nil
+}
+
+// clientInstructions returns setup instructions for common applications.
+func clientInstructions(cs ClientSettings) []ClientInstructions {
+	securityName := map[string]string{"tls": "SSL/TLS", "starttls": "STARTTLS", "none": "None"}
+	imap := fmt.Sprintf("incoming IMAP server %s, port %d, connection security %s", cs.IMAP.Host, cs.IMAP.Port, securityName[cs.IMAP.Security])
+	smtp := fmt.Sprintf("outgoing SMTP server %s, port %d, connection security %s", cs.Submission.Host, cs.Submission.Port, securityName[cs.Submission.Security])
+	manual := fmt.Sprintf("Use %s, and %s, with username %s and normal password authentication.", imap, smtp, cs.Address)
+	start := fmt.Sprintf("Add an email account with your name, email address %s and password.", cs.Address)
+
+	var l []ClientInstructions
+	add := func(client string, steps ...string) {
+		var nsteps []string
+		for _, s := range steps {
+			if s != "" {
+				nsteps = append(nsteps, s)
+			}
+		}
+		l = append(l, ClientInstructions{client, nsteps})
+	}
+	ifs := func(cond bool, s string) string {
+		if cond {
+			return s
+		}
+		return ""
+	}
+	ifAutoconfig := func(auto, manualSetup string) string {
+		if cs.Autoconfig != "" {
+			return auto
+		}
+		return manualSetup
+	}
+
+	add("Thunderbird",
+		start,
+		ifAutoconfig("The settings are found automatically. If not, choose manual configuration. "+manual, "Choose manual configuration. "+manual),
+		ifs(cs.DAV != "", fmt.Sprintf("For calendars, add a new calendar \"On the network\" with location %s and your email address as username. For contacts, add a CardDAV address book with the same location.", cs.DAV)),
+	)
+	add("Outlook",
+		start,
+		ifAutoconfig("The settings are found through autodiscover. If not, choose advanced setup, account type IMAP. "+manual, "Choose advanced setup, account type IMAP. "+manual),
+	)
+	add("iOS and macOS Mail",
+		ifs(cs.MobileConfig != "", fmt.Sprintf("Download the configuration profile at %s and install it under Profiles in System Settings (macOS) or Settings (iOS), then enter your password.", cs.MobileConfig)),
+		ifs(cs.MobileConfig == "", "In Settings, add a Mail account of type Other, as IMAP account. "+manual),
+		ifs(cs.DAV != "", fmt.Sprintf("For calendars and contacts, add CalDAV and CardDAV accounts of type Other, with server %s and your email address as username.", cs.DAV)),
+	)
+	add("Android, Thunderbird for Android/K-9 Mail",
+		start,
+		ifAutoconfig("The settings are found automatically. If not, choose manual setup. "+manual, "Choose manual setup. "+manual),
+		ifs(cs.DAV != "", fmt.Sprintf("For calendars and contacts, use a CalDAV/CardDAV app such as DAVx⁵ with base URL %s and your email address as username.", cs.DAV)),
+	)
+	add("FairEmail",
+		"In the setup wizard, choose \"Other provider\" and enter your name, email address and password.",
+		ifAutoconfig("The settings are found automatically. If not, use manual setup and account options. "+manual, "Use manual setup and account options. "+manual),
+	)
+	return l
+}
@@ -0,0 +1,111 @@
+package mox
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/mjl-/mox/smtp"
+)
+
+func TestClientSettings(t *testing.T) {
+	ctx := context.Background()
+	log := pkglog
+
+	tcheck := func(err error, msg string) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("%s: %v", msg, err)
+		}
+	}
+
+	dir := t.TempDir()
+	ConfigStaticPath = filepath.Join(dir, "mox.conf")
+	ConfigDynamicPath = filepath.Join(dir, "domains.conf")
+	defer func() {
+		ConfigStaticPath = ""
+		ConfigDynamicPath = ""
+	}()
+
+	const static = `DataDir: data
+User: 1000
+LogLevel: info
+Hostname: mail.mox.example
+Postmaster:
+	Account: mjl
+	Mailbox: postmaster
+Listeners:
+	public:
+		IPs:
+			- 127.0.0.1
+		Submission:
+			Enabled: true
+			NoRequireSTARTTLS: true
+		IMAP:
+			Enabled: true
+			NoRequireSTARTTLS: true
+		WebmailHTTP:
+			Enabled: true
+			Port: 8080
+		AutoconfigHTTPS:
+			Enabled: true
+			Port: 80
+			NonTLS: true
+	internal:
+		IPs:
+			- 127.0.0.2
+		DAVHTTP:
+			Enabled: true
+			Path: /caldav/
+`
+	const dynamic = `Domains:
+	mox.example: nil
+Accounts:
+	mjl:
+		Domain: mox.example
+		Destinations:
+			mjl@mox.example: nil
+`
+	err := os.WriteFile(ConfigStaticPath, []byte(static), 0660)
+	tcheck(err, "write static config")
+	err = os.WriteFile(ConfigDynamicPath, []byte(dynamic), 0660)
+	tcheck(err, "write dynamic config")
+	if errs := LoadConfig(ctx, log, false, false); len(errs) > 0 {
+		t.Fatalf("load config: %v", errs)
+	}
+
+	_, err = ClientSettingsAddress(smtp.NewAddress("mjl", Conf.Static.HostnameDomain))
+	if err == nil {
+		t.Fatalf("got client settings for unknown domain")
+	}
+
+	addr, err := smtp.ParseAddress("mjl@mox.example")
+	tcheck(err, "parse address")
+	cs, err := ClientSettingsAddress(addr)
+	tcheck(err, "client settings")
+	clients := cs.Clients
+	cs.Clients = nil
+	exp := ClientSettings{
+		Address:      "mjl@mox.example",
+		IMAP:         ClientServer{"mail.mox.example", 143, "none"},
+		Submission:   ClientServer{"mail.mox.example", 587, "none"},
+		Webmail:      "http://mail.mox.example:8080/webmail/",
+		DAV:          "http://mail.mox.example/caldav/",
+		Autoconfig:   "http://autoconfig.mox.example/mail/config-v1.1.xml?emailaddress=mjl%40mox.example",
+		Autodiscover: "http://autodiscover.mox.example/autodiscover/autodiscover.xml",
+		MobileConfig: "http://autoconfig.mox.example/profile.mobileconfig?addresses=mjl%40mox.example",
+	}
+	if !reflect.DeepEqual(cs, exp) {
+		t.Fatalf("got %#v, expected %#v", cs, exp)
+	}
+	if len(clients) != 5 {
+		t.Fatalf("got instructions for %d clients, expected 5", len(clients))
+	}
+	for _, c := range clients {
+		if len(c.Steps) == 0 {
+			t.Fatalf("no steps for client %s", c.Client)
+		}
+	}
+}
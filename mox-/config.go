@@ -12,15 +12,18 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"os/exec"
 	"os/user"
 	"path/filepath"
 	"regexp"
@@ -41,10 +44,12 @@ import (
 	"github.com/mjl-/mox/config"
 	"github.com/mjl-/mox/dkim"
 	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/extauth"
 	"github.com/mjl-/mox/message"
 	"github.com/mjl-/mox/mlog"
 	"github.com/mjl-/mox/moxio"
 	"github.com/mjl-/mox/mtasts"
+	"github.com/mjl-/mox/ocsp"
 	"github.com/mjl-/mox/smtp"
 )
 
@@ -67,7 +72,10 @@ var ErrConfig = errors.New("config error")
 //
 // Use methods to lookup a domain/account/address in the dynamic configuration.
 type Config struct {
-	Static config.Static // Does not change during the lifetime of a running instance.
+	Static config.Static // Only fields that can be reloaded change while running, see ReloadStatic.
+	// Contents of the static config file as loaded at startup, and as last applied
+	// by ReloadStatic, for comparing with the file on disk.
+	staticFile, staticApplied *configFile
 
 	logMutex sync.Mutex // For accessing the log levels.
 	Log      map[string]slog.Level
@@ -115,6 +123,50 @@ func (c *Config) LogLevelRemove(log mlog.Log, pkg string) {
 	mlog.SetConfig(c.Log)
 }
 
+// LogLevelsReload resets the log levels to those in the static config file,
+// e.g. after changes made in the admin web interface, or to the file. Other
+// changes to the file are ignored.
+func (c *Config) LogLevelsReload(log mlog.Log) error {
+	cf, err := readConfigFile(ConfigStaticPath)
+	if err != nil {
+		return fmt.Errorf("open config file: %v", err)
+	}
+	var static config.Static
+	if err := sconf.Parse(bytes.NewReader(cf.buf), &static); err != nil {
+		return fmt.Errorf("parsing %s%v", ConfigStaticPath, err)
+	}
+	l, errs := logLevels(static)
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	c.logMutex.Lock()
+	defer c.logMutex.Unlock()
+	c.Log = l
+	log.Print("log levels reloaded from config file")
+	mlog.SetConfig(c.Log)
+	return nil
+}
+
+// logLevels returns the log levels from the static config.
+func logLevels(c config.Static) (map[string]slog.Level, []error) {
+	var errs []error
+	l := map[string]slog.Level{}
+	if logLevel, ok := mlog.Levels[c.LogLevel]; ok {
+		l[""] = logLevel
+	} else {
+		errs = append(errs, fmt.Errorf("invalid log level %q", c.LogLevel))
+	}
+	for pkg, s := range c.PackageLogLevels {
+		if logLevel, ok := mlog.Levels[s]; ok {
+			l[pkg] = logLevel
+		} else {
+			errs = append(errs, fmt.Errorf("invalid package log level %q", s))
+		}
+	}
+	return l, errs
+}
+
 // copyLogLevels returns a copy of c.Log, for modifications.
 // must be called with log lock held.
 func (c *Config) copyLogLevels() map[string]slog.Level {
@@ -249,6 +301,29 @@ func (c *Config) AccountDestination(addr string) (accDest AccountDestination, al
 	return
 }
 
+// DomainAdminDomains returns the domains that the login address can manage as
+// domain admin, sorted by name.
+func (c *Config) DomainAdminDomains(address string) (l []dns.Domain) {
+	addr, err := smtp.ParseAddress(address)
+	if err != nil {
+		return nil
+	}
+	c.withDynamicLock(func() {
+		for _, dom := range c.Dynamic.Domains {
+			for _, s := range dom.Admins {
+				if a, err := smtp.ParseAddress(s); err == nil && strings.EqualFold(a.String(), addr.String()) {
+					l = append(l, dom.Domain)
+					break
+				}
+			}
+		}
+	})
+	sort.Slice(l, func(i, j int) bool {
+		return l[i].Name() < l[j].Name()
+	})
+	return l
+}
+
 func (c *Config) Routes(accountName string, domain dns.Domain) (accountRoutes, domainRoutes, globalRoutes []config.Route) {
 	c.withDynamicLock(func() {
 		acc := c.Dynamic.Accounts[accountName]
@@ -262,7 +337,32 @@ func (c *Config) Routes(accountName string, domain dns.Domain) (accountRoutes, d
 	return
 }
 
+// AddressRewrites returns the address rewrite rules of domain, and the global
+// rules.
+func (c *Config) AddressRewrites(domain dns.Domain) (domainRules, globalRules []config.AddressRewrite) {
+	c.withDynamicLock(func() {
+		domainRules = c.Dynamic.Domains[domain.Name()].AddressRewrites
+		globalRules = c.Dynamic.AddressRewrites
+	})
+	return
+}
+
 func (c *Config) allowACMEHosts(log mlog.Log, checkACMEHosts bool) {
+	// Hosts configured for a specific ACME provider are allowed at that provider,
+	// regardless of the listener.
+	hostProviders := map[dns.Domain]*autotls.Manager{}
+	for _, acme := range c.Static.ACME {
+		for _, d := range acme.HostDomains {
+			hostProviders[d] = acme.Manager
+		}
+	}
+	managerHostnames := map[*autotls.Manager]map[dns.Domain]struct{}{}
+	for _, acme := range c.Static.ACME {
+		if acme.Manager != nil && len(acme.HostDomains) > 0 {
+			managerHostnames[acme.Manager] = map[dns.Domain]struct{}{}
+		}
+	}
+
 	for _, l := range c.Static.Listeners {
 		if l.TLS == nil || l.TLS.ACME == "" {
 			continue
@@ -300,6 +400,14 @@ func (c *Config) allowACMEHosts(log mlog.Log, checkACMEHosts bool) {
 				}
 			}
 
+			if l.WKDHTTPS.Enabled && !l.WKDHTTPS.NonTLS {
+				if d, err := dns.ParseDomain("openpgpkey." + dom.Domain.ASCII); err != nil {
+					log.Errorx("parsing openpgpkey domain", err, slog.Any("domain", dom.Domain))
+				} else {
+					hostnames[d] = struct{}{}
+				}
+			}
+
 			if dom.ClientSettingsDomain != "" {
 				hostnames[dom.ClientSettingsDNSDomain] = struct{}{}
 			}
@@ -314,15 +422,32 @@ func (c *Config) allowACMEHosts(log mlog.Log, checkACMEHosts bool) {
 			}
 		}
 
-		public := c.Static.Listeners["public"]
-		ips := public.IPs
-		if len(public.NATIPs) > 0 {
-			ips = public.NATIPs
+		if managerHostnames[m] == nil {
+			managerHostnames[m] = map[dns.Domain]struct{}{}
+		}
+		for h := range hostnames {
+			if pm := hostProviders[h]; pm != nil {
+				if _, ok := managerHostnames[pm]; ok {
+					managerHostnames[pm][h] = struct{}{}
+				}
+			} else {
+				managerHostnames[m][h] = struct{}{}
+			}
 		}
-		if public.IPsNATed {
-			ips = nil
+	}
+
+	public := c.Static.Listeners["public"]
+	ips := public.IPs
+	if len(public.NATIPs) > 0 {
+		ips = public.NATIPs
+	}
+	if public.IPsNATed {
+		ips = nil
+	}
+	for m, hostnames := range managerHostnames {
+		if m != nil {
+			m.SetAllowedHostnames(log, dns.StrictResolver{Pkg: "autotls", Log: log.Logger}, hostnames, ips, checkACMEHosts)
 		}
-		m.SetAllowedHostnames(log, dns.StrictResolver{Pkg: "autotls", Log: log.Logger}, hostnames, ips, checkACMEHosts)
 	}
 }
 
@@ -336,6 +461,14 @@ func writeDynamic(ctx context.Context, log mlog.Log, c config.Dynamic) error {
 		return fmt.Errorf("%w: %v", ErrConfig, errs[0])
 	}
 
+	// Writing the file would replace includes, environment variables and file
+	// references with their values.
+	if processed, err := configFileProcessed(ConfigDynamicPath); err != nil {
+		return fmt.Errorf("reading domains.conf: %v", err)
+	} else if processed {
+		return fmt.Errorf("%w: domains.conf has includes, environment variables or file references, edit the file instead", ErrConfig)
+	}
+
 	var b bytes.Buffer
 	err := sconf.Write(&b, c)
 	if err != nil {
@@ -419,7 +552,7 @@ func LoadConfig(ctx context.Context, log mlog.Log, doLoadTLSKeyCerts, checkACMEH
 // SetConfig sets a new config. Not to be used during normal operation.
 func SetConfig(c *Config) {
 	// Cannot just assign *c to Conf, it would copy the mutex.
-	Conf = Config{c.Static, sync.Mutex{}, c.Log, sync.Mutex{}, c.Dynamic, c.dynamicMtime, c.DynamicLastCheck, c.accountDestinations, c.aliases}
+	Conf = Config{c.Static, c.staticFile, c.staticApplied, sync.Mutex{}, c.Log, sync.Mutex{}, c.Dynamic, c.dynamicMtime, c.DynamicLastCheck, c.accountDestinations, c.aliases}
 
 	// If we have non-standard CA roots, use them for all HTTPS requests.
 	if Conf.Static.TLS.CertPool != nil {
@@ -453,17 +586,17 @@ func ParseConfig(ctx context.Context, log mlog.Log, p string, checkOnly, doLoadT
 		},
 	}
 
-	f, err := os.Open(p)
+	cf, err := readConfigFile(p)
 	if err != nil {
-		if os.IsNotExist(err) && os.Getenv("MOXCONF") == "" {
+		if errors.Is(err, fs.ErrNotExist) && os.Getenv("MOXCONF") == "" {
 			return nil, []error{fmt.Errorf("open config file: %v (hint: use mox -config ... or set MOXCONF=...)", err)}
 		}
 		return nil, []error{fmt.Errorf("open config file: %v", err)}
 	}
-	defer f.Close()
-	if err := sconf.Parse(f, &c.Static); err != nil {
+	if err := sconf.Parse(bytes.NewReader(cf.buf), &c.Static); err != nil {
 		return nil, []error{fmt.Errorf("parsing %s%v", p, err)}
 	}
+	c.staticFile = &cf
 
 	if xerrs := PrepareStaticConfig(ctx, log, p, c, checkOnly, doLoadTLSKeyCerts); len(xerrs) > 0 {
 		return nil, xerrs
@@ -479,6 +612,25 @@ func ParseConfig(ctx context.Context, log mlog.Log, p string, checkOnly, doLoadT
 	return c, errs
 }
 
+// parseIPNet parses an IP network in CIDR notation, or a single IP address.
+func parseIPNet(s string) (net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return net.IPNet{}, fmt.Errorf("invalid ip %q", s)
+		} else if ip.To4() != nil {
+			s += "/32"
+		} else {
+			s += "/128"
+		}
+	}
+	_, ipnet, err := net.ParseCIDR(s)
+	if err != nil {
+		return net.IPNet{}, fmt.Errorf("%q: %v", s, err)
+	}
+	return *ipnet, nil
+}
+
 // PrepareStaticConfig parses the static config file and prepares data structures
 // for starting mox. If checkOnly is set no substantial changes are made, like
 // creating an ACME registration.
@@ -499,18 +651,36 @@ func PrepareStaticConfig(ctx context.Context, log mlog.Log, configFile string, c
 	}
 
 	// Post-process logging config.
-	if logLevel, ok := mlog.Levels[c.LogLevel]; ok {
-		conf.Log = map[string]slog.Level{"": logLevel}
-	} else {
-		addErrorf("invalid log level %q", c.LogLevel)
+	var logErrs []error
+	conf.Log, logErrs = logLevels(*c)
+	errs = append(errs, logErrs...)
+	switch c.LogFormat {
+	case "", "logfmt", "json":
+	default:
+		addErrorf("invalid log format %q, must be logfmt or json", c.LogFormat)
 	}
-	for pkg, s := range c.PackageLogLevels {
-		if logLevel, ok := mlog.Levels[s]; ok {
-			conf.Log[pkg] = logLevel
-		} else {
-			addErrorf("invalid package log level %q", s)
+	switch c.DeliveryDurability {
+	case "", "full", "relaxed":
+	default:
+		addErrorf("invalid delivery durability %q, must be full or relaxed", c.DeliveryDurability)
+	}
+	if ls := c.LogSyslog; ls != nil {
+		switch ls.Network {
+		case "":
+			if ls.Address != "" {
+				addErrorf("log syslog: address requires network")
+			}
+		case "udp", "tcp", "unixgram":
+			if ls.Address == "" {
+				addErrorf("log syslog: address required for network %q", ls.Network)
+			}
+		default:
+			addErrorf("log syslog: unknown network %q, must be udp, tcp or unixgram", ls.Network)
 		}
 	}
+	if c.LogRecent < -1 {
+		addErrorf("log recent must be -1, 0 or positive")
+	}
 
 	if c.User == "" {
 		c.User = "mox"
@@ -558,6 +728,393 @@ func PrepareStaticConfig(ctx context.Context, log mlog.Log, configFile string, c
 		c.HostTLSRPT.ParsedLocalpart = tlsrptLocalpart
 	}
 
+	if c.OIDC != nil {
+		if u, err := url.Parse(c.OIDC.Issuer); err != nil {
+			addErrorf("oidc: parsing issuer url: %v", err)
+		} else if u.Scheme != "https" && !(u.Scheme == "http" && (u.Hostname() == "localhost" || net.ParseIP(u.Hostname()).IsLoopback())) {
+			addErrorf("oidc: issuer url must be https")
+		} else if u.RawQuery != "" || u.Fragment != "" {
+			addErrorf("oidc: issuer url must not have query or fragment")
+		}
+		if c.OIDC.ClientID == "" {
+			addErrorf("oidc: client id required")
+		}
+		for _, s := range c.OIDC.Admins {
+			if _, err := smtp.ParseAddress(s); err != nil {
+				addErrorf("oidc: parsing admin address %q: %v", s, err)
+			}
+		}
+	}
+
+	if p := c.WebmailRemoteContentProxy; p.MaxSize < 0 || p.CacheSize < 0 || p.CacheTime < 0 {
+		addErrorf("webmail remote content proxy: sizes and cache time must not be negative")
+	}
+
+	if r := c.Replication; r != nil {
+		buf, err := os.ReadFile(configDirPath(configFile, r.TokenFile))
+		if err != nil {
+			addErrorf("replication: reading token file: %v", err)
+		} else if token := strings.TrimSpace(string(buf)); len(token) < 16 {
+			addErrorf("replication: token must be at least 16 characters")
+		} else {
+			r.Token = token
+		}
+	}
+
+	if s := c.Scrub; s != nil && (s.Interval < 0 || s.BytesPerSecond < 0) {
+		addErrorf("scrub: interval and bytes per second must not be negative")
+	}
+
+	if s := c.SpamScan; s != nil {
+		if s.Rspamd == nil && s.Spamd == nil {
+			addErrorf("spamscan: at least one of Rspamd and Spamd must be configured")
+		}
+		if s.Rspamd != nil {
+			if u, err := url.Parse(s.Rspamd.URL); err != nil {
+				addErrorf("spamscan: parsing rspamd url: %v", err)
+			} else if u.Scheme != "http" && u.Scheme != "https" {
+				addErrorf("spamscan: rspamd url must be http or https")
+			}
+		}
+		if s.Spamd != nil && s.Spamd.Address == "" {
+			addErrorf("spamscan: spamd address required")
+		}
+		if s.Timeout < 0 || s.Weight < 0 || s.Weight > 1 {
+			addErrorf("spamscan: timeout must not be negative and weight must be between 0 and 1")
+		}
+	}
+
+	if cb := c.ContentBlocklists; cb != nil {
+		cb.URIBLZones = nil
+		for _, s := range cb.URIBLs {
+			d, err := dns.ParseDomain(s)
+			if err != nil {
+				addErrorf("content blocklists: invalid uribl zone %q: %v", s, err)
+				continue
+			}
+			cb.URIBLZones = append(cb.URIBLZones, d)
+		}
+		for i, f := range cb.HashFiles {
+			cb.HashFiles[i] = configDirPath(configFile, f)
+			if _, err := os.Stat(cb.HashFiles[i]); err != nil {
+				log.Errorx("content blocklists: hash file not present", err, slog.String("file", cb.HashFiles[i]))
+			}
+		}
+	}
+
+	if s := c.DNSBLScoring; s != nil {
+		if len(s.Lists) == 0 {
+			addErrorf("dnsbl scoring: at least one list required")
+		}
+		for i, l := range s.Lists {
+			d, err := dns.ParseDomain(l.Zone)
+			if err != nil {
+				addErrorf("dnsbl scoring: invalid zone %q: %v", l.Zone, err)
+				continue
+			}
+			if l.Weight == 0 {
+				addErrorf("dnsbl scoring: weight for zone %q must not be zero", l.Zone)
+			}
+			s.Lists[i].ZoneDomain = d
+		}
+		if s.RejectScore < 0 || s.GreylistScore < 0 || s.TagScore < 0 || s.GreylistDelay < 0 || s.CacheTTL < 0 {
+			addErrorf("dnsbl scoring: scores, delay and cache ttl must not be negative")
+		}
+		reject := s.RejectScore
+		if reject == 0 {
+			reject = 10
+		}
+		if s.GreylistScore >= reject || s.TagScore >= reject || s.GreylistScore > 0 && s.TagScore >= s.GreylistScore {
+			addErrorf("dnsbl scoring: tag score must be below greylist score, and both below reject score")
+		}
+	}
+
+	if q := c.Quarantine; q != nil {
+		if len(q.Reasons) == 0 {
+			addErrorf("quarantine: at least one reason required")
+		}
+		for _, r := range q.Reasons {
+			switch r {
+			case "junk-content", "junk-content-strict", "dns-blocklisted", "uri-blocklisted", "hash-blocklisted", "spamscan", "dmarc-policy", "iprev", "spamtrap":
+			default:
+				addErrorf("quarantine: unknown reason %q", r)
+			}
+		}
+		if q.Expiration < 0 {
+			addErrorf("quarantine: expiration must not be negative")
+		}
+	}
+
+	if qw := c.QueueWorkers; qw != nil {
+		if qw.Workers < 0 || qw.PerDomain < 0 {
+			addErrorf("queue workers: workers and per domain must not be negative")
+		}
+	}
+
+	if dc := c.DNSCache; dc != nil {
+		if dc.TTL < 0 || dc.NegativeTTL < 0 || dc.MaxEntries < 0 {
+			addErrorf("dns cache: ttl, negative ttl and max entries must not be negative")
+		}
+	}
+
+	if ms := c.MetricsSeries; ms != nil {
+		if ms.QueueDomains < 0 {
+			addErrorf("metrics series: queue domains must not be negative")
+		}
+		if ms.DNSCheckInterval != 0 && ms.DNSCheckInterval < time.Hour {
+			addErrorf("metrics series: dns check interval must be zero or at least 1h")
+		}
+	}
+
+	if a := c.Alerting; a != nil {
+		for _, s := range a.Email {
+			if _, err := smtp.ParseAddress(s); err != nil {
+				addErrorf("alerting: parsing email address %q: %v", s, err)
+			}
+		}
+		if a.WebhookURL != "" {
+			if u, err := url.Parse(a.WebhookURL); err != nil {
+				addErrorf("alerting: parsing webhook url: %v", err)
+			} else if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+				addErrorf("alerting: webhook url must be an http or https url")
+			}
+		}
+		switch a.WebhookFormat {
+		case "", "json", "slack", "text":
+		default:
+			addErrorf("alerting: unknown webhook format %q, must be json, slack or text", a.WebhookFormat)
+		}
+		if a.CheckInterval != 0 && a.CheckInterval < time.Minute {
+			addErrorf("alerting: check interval must be zero or at least 1m")
+		}
+		if a.RepeatInterval < 0 || a.CertExpiryDays < 0 || a.QueueSize < 0 || a.DiskFreePercent < 0 || a.DiskFreePercent > 100 || a.AuthFailures < 0 {
+			addErrorf("alerting: intervals and thresholds must not be negative, and disk free percentage must be at most 100")
+		}
+	}
+
+	if t := c.Tracing; t != nil {
+		if u, err := url.Parse(t.Endpoint); err != nil {
+			addErrorf("tracing: parsing endpoint url: %v", err)
+		} else if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+			addErrorf("tracing: endpoint must be an http or https url")
+		}
+		if t.SampleRatio < 0 || t.SampleRatio > 1 {
+			addErrorf("tracing: sample ratio must be between 0 and 1")
+		}
+	}
+
+	// Transports for forwarding messages to another mail server, with SMTP.
+	checkForwardTransport := func(what, name string) {
+		if t, ok := c.Transports[name]; !ok {
+			addErrorf("%s transport %q not found", what, name)
+		} else if t.SMTP == nil && t.Submission == nil && t.Submissions == nil {
+			addErrorf("%s transport %q must have method SMTP, Submission or Submissions", what, name)
+		}
+	}
+
+	if n := c.Node; n != nil {
+		switch n.Role {
+		case "frontend":
+			if n.StorageTransport == "" {
+				addErrorf("node: frontend requires a storage transport")
+			} else {
+				checkForwardTransport("node: storage", n.StorageTransport)
+			}
+			for name, l := range c.Listeners {
+				if l.IMAP.Enabled || l.IMAPS.Enabled || l.Submission.Enabled || l.Submissions.Enabled {
+					addErrorf("node: listener %s: frontend nodes cannot serve imap or submission, accounts are on the storage node", name)
+				}
+			}
+		case "storage":
+		default:
+			addErrorf("node: unknown role %q, must be frontend or storage", n.Role)
+		}
+		n.FrontendNets = nil
+		for _, s := range n.FrontendIPs {
+			ipnet, err := parseIPNet(s)
+			if err != nil {
+				addErrorf("node: parsing frontend ip: %v", err)
+				continue
+			}
+			n.FrontendNets = append(n.FrontendNets, ipnet)
+		}
+	}
+
+	if smx := c.SecondaryMX; smx != nil {
+		if len(smx.Domains) == 0 {
+			addErrorf("secondary mx: at least one domain required")
+		}
+		if smx.SyncInterval != 0 && smx.SyncInterval < time.Minute {
+			addErrorf("secondary mx: sync interval must be zero or at least 1m")
+		}
+		for name, d := range smx.Domains {
+			dom, err := dns.ParseDomain(name)
+			if err != nil {
+				addErrorf("secondary mx: parsing domain %q: %v", name, err)
+			}
+			d.Domain = dom
+			checkForwardTransport(fmt.Sprintf("secondary mx: domain %s:", name), d.Transport)
+			if d.AddressesFile != "" {
+				d.AddressesFile = configDirPath(configFile, d.AddressesFile)
+			}
+			if d.AddressesURL != "" {
+				if d.AddressesFile == "" {
+					addErrorf("secondary mx: domain %s: addresses url requires addresses file", name)
+				}
+				if u, err := url.Parse(d.AddressesURL); err != nil {
+					addErrorf("secondary mx: domain %s: parsing addresses url: %v", name, err)
+				} else if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+					addErrorf("secondary mx: domain %s: addresses url must be an http or https url", name)
+				}
+			} else if d.AddressesFile != "" {
+				if _, err := os.Stat(d.AddressesFile); err != nil {
+					log.Errorx("secondary mx: addresses file not present", err, slog.String("file", d.AddressesFile))
+				}
+			}
+			smx.Domains[name] = d
+		}
+	}
+
+	if le := c.SMTPLineEndings; le != nil {
+		check := func(name, action string) {
+			switch action {
+			case "", "reject", "log":
+			default:
+				addErrorf("smtp line endings: unknown action %q for %s, must be reject or log", action, name)
+			}
+		}
+		check("BareCR", le.BareCR)
+		check("BareLF", le.BareLF)
+		check("BadDataEnd", le.BadDataEnd)
+	}
+
+	if ab := c.AutoBan; ab != nil {
+		if ab.AuthFailures < -1 || ab.ProtocolErrors < -1 {
+			addErrorf("autoban: auth failures and protocol errors must be -1, 0 for the default, or positive")
+		}
+		if ab.Window < 0 || ab.Duration < 0 {
+			addErrorf("autoban: window and duration must not be negative")
+		}
+		if ab.IPv4Prefix < 0 || ab.IPv4Prefix > 32 {
+			addErrorf("autoban: ipv4 prefix must be between 1 and 32, or 0 for the default")
+		}
+		if ab.IPv6Prefix < 0 || ab.IPv6Prefix > 128 {
+			addErrorf("autoban: ipv6 prefix must be between 1 and 128, or 0 for the default")
+		}
+		ab.ExemptNets = nil
+		for _, s := range ab.Exempt {
+			ipnet, err := parseIPNet(s)
+			if err != nil {
+				addErrorf("autoban: parsing exempt ip: %v", err)
+				continue
+			}
+			ab.ExemptNets = append(ab.ExemptNets, ipnet)
+		}
+	}
+
+	if c.PasswordPolicy != nil {
+		if err := checkPasswordPolicy(*c.PasswordPolicy); err != nil {
+			addErrorf("password policy: %v", err)
+		}
+	}
+
+	for i, r := range c.TLSFingerprintRules {
+		if (r.JA3 == "") == (r.JA4 == "") {
+			addErrorf("tls fingerprint rule %d: exactly one of ja3 and ja4 must be set", i+1)
+		}
+		if r.JA3 != "" {
+			if buf, err := hex.DecodeString(r.JA3); err != nil || len(buf) != 16 || strings.ToLower(r.JA3) != r.JA3 {
+				addErrorf("tls fingerprint rule %d: ja3 must be 32 lower-case hexadecimal characters", i+1)
+			}
+		}
+		if r.JA4 != "" && len(strings.Split(r.JA4, "_")) != 3 {
+			addErrorf("tls fingerprint rule %d: ja4 must have three parts separated by underscores", i+1)
+		}
+		for _, p := range r.Protocols {
+			switch p {
+			case "smtp", "submission", "submissions", "imap", "imaps":
+			default:
+				addErrorf("tls fingerprint rule %d: unknown protocol %q, must be smtp, submission, submissions, imap or imaps", i+1, p)
+			}
+		}
+		switch r.Action {
+		case "", "reject", "log":
+		default:
+			addErrorf("tls fingerprint rule %d: unknown action %q, must be reject or log", i+1, r.Action)
+		}
+	}
+
+	if fr := c.DMARCFailureReports; fr != nil {
+		if len(fr.Domains) == 0 {
+			addErrorf("dmarc failure reports: at least one domain required")
+		}
+		for name, d := range fr.Domains {
+			dom, err := dns.ParseDomain(name)
+			if err != nil {
+				addErrorf("dmarc failure reports: parsing domain %q: %v", name, err)
+			}
+			d.Domain = dom
+			if d.MaxHeaderSize < 0 || d.BodySize < 0 || d.MaxPerHour < 0 {
+				addErrorf("dmarc failure reports: domain %s: sizes and maximum per hour must not be negative", name)
+			}
+			for _, h := range d.Headers {
+				if h == "" || strings.ContainsAny(h, ": \t\r\n") {
+					addErrorf("dmarc failure reports: domain %s: invalid header field name %q", name, h)
+				}
+			}
+			fr.Domains[name] = d
+		}
+	}
+
+	if al := c.WebserverAccessLog; al != nil {
+		if al.File == "" {
+			addErrorf("webserver access log: file required")
+		}
+		if al.MaxSize < 0 || al.MaxFiles < 0 {
+			addErrorf("webserver access log: max size and max files must not be negative")
+		}
+		al.Path = dataDirPath(configFile, c.DataDir, al.File)
+	}
+
+	if sg := c.SubmissionGuard; sg != nil {
+		switch sg.Action {
+		case "", "alert", "throttle", "freeze":
+		default:
+			addErrorf("submission guard: unknown action %q, must be alert, throttle or freeze", sg.Action)
+		}
+		if sg.ThrottleDuration < 0 || sg.RecipientSpikeFactor < 0 || sg.RecipientSpikeMin < 0 || sg.BounceMin < 0 || sg.BounceRate < 0 || sg.BounceRate > 1 {
+			addErrorf("submission guard: durations, factors and minimums must not be negative, and bounce rate must be between 0 and 1")
+		}
+	}
+
+	if me := c.MessageEncryption; me != nil {
+		var buf []byte
+		var err error
+		if (me.KeyFile == "") == (me.KeyCommand == "") {
+			addErrorf("message encryption: exactly one of KeyFile and KeyCommand must be set")
+		} else if me.KeyFile != "" {
+			buf, err = os.ReadFile(configDirPath(configFile, me.KeyFile))
+			if err != nil {
+				addErrorf("message encryption: reading key file: %v", err)
+			}
+		} else {
+			buf, err = exec.CommandContext(ctx, "sh", "-c", me.KeyCommand).Output()
+			if err != nil {
+				addErrorf("message encryption: running key command: %v", err)
+			}
+		}
+		if err == nil && buf != nil {
+			key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(buf)))
+			if err != nil {
+				addErrorf("message encryption: parsing base64 key: %v", err)
+			} else if len(key) != 32 {
+				addErrorf("message encryption: key must be 32 bytes, got %d", len(key))
+			} else {
+				me.Key = key
+			}
+		}
+	}
+
 	// Return private key for host name for use with an ACME. Used to return the same
 	// private key as pre-generated for use with DANE, with its public key in DNS.
 	// We only use this key for Listener's that have this ACME configured, and for
@@ -648,7 +1205,83 @@ func PrepareStaticConfig(ctx context.Context, log mlog.Log, configFile string, c
 			}
 		}
 
+		for i, fb := range acme.Fallback {
+			if fb == name {
+				addErrorf("acme provider %q: cannot be its own fallback", name)
+			} else if _, ok := c.ACME[fb]; !ok {
+				addErrorf("acme provider %q: unknown fallback acme provider %q", name, fb)
+			} else if slices.Contains(acme.Fallback[:i], fb) {
+				addErrorf("acme provider %q: duplicate fallback acme provider %q", name, fb)
+			}
+		}
+		acme.HostDomains = nil
+		for _, h := range acme.Hosts {
+			d, err := dns.ParseDomain(h)
+			if err != nil {
+				addErrorf("acme provider %q: parsing host %q: %v", name, h, err)
+				continue
+			}
+			acme.HostDomains = append(acme.HostDomains, d)
+		}
+
+		var dns01 *autotls.DNS01
+		if d := acme.DNS01; d != nil {
+			dns01 = &autotls.DNS01{PropagationDelay: d.PropagationDelay, RenewBefore: acme.RenewBefore}
+			if len(d.Hostnames) == 0 {
+				addErrorf("acme provider %q: dns01 needs at least one hostname", name)
+			}
+			for _, h := range d.Hostnames {
+				wildcard := strings.HasPrefix(h, "*.")
+				hd, err := dns.ParseDomain(strings.TrimPrefix(h, "*."))
+				if err != nil {
+					addErrorf("acme provider %q: parsing dns01 hostname %q: %v", name, h, err)
+					continue
+				}
+				n := hd.ASCII
+				if wildcard {
+					n = "*." + n
+				}
+				dns01.Hostnames = append(dns01.Hostnames, n)
+			}
+			if (d.RFC2136 == nil) == (d.Command == "") {
+				addErrorf("acme provider %q: dns01 needs exactly one of RFC2136 and Command", name)
+			} else if d.Command != "" {
+				dns01.Updater = autotls.Command{Command: d.Command}
+			} else {
+				u := d.RFC2136
+				if autotls.TSIGAlgorithm(u.TSIGAlgorithm) == nil {
+					addErrorf("acme provider %q: unknown tsig algorithm %q", name, u.TSIGAlgorithm)
+				}
+				if _, _, err := net.SplitHostPort(u.Server); err != nil {
+					addErrorf("acme provider %q: rfc2136 server %q must be host:port: %v", name, u.Server, err)
+				}
+				var zone string
+				if u.Zone != "" {
+					zd, err := dns.ParseDomain(u.Zone)
+					if err != nil {
+						addErrorf("acme provider %q: parsing rfc2136 zone %q: %v", name, u.Zone, err)
+					}
+					zone = zd.ASCII
+				}
+				var secret []byte
+				buf, err := os.ReadFile(configDirPath(configFile, u.TSIGSecretFile))
+				if err != nil {
+					addErrorf("acme provider %q: reading tsig secret: %v", name, err)
+				} else if secret, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(buf))); err != nil {
+					addErrorf("acme provider %q: parsing tsig secret as base64: %v", name, err)
+				}
+				dns01.Updater = autotls.RFC2136{
+					Server:    u.Server,
+					Zone:      zone,
+					KeyName:   strings.TrimSuffix(u.TSIGKeyName, "."),
+					Algorithm: u.TSIGAlgorithm,
+					Secret:    secret,
+				}
+			}
+		}
+
 		if checkOnly {
+			c.ACME[name] = acme
 			continue
 		}
 
@@ -659,15 +1292,54 @@ func PrepareStaticConfig(ctx context.Context, log mlog.Log, configFile string, c
 			addErrorf("loading ACME identity for %q: %s", name, err)
 		}
 		acme.Manager = manager
+		if manager != nil && dns01 != nil {
+			manager.SetDNS01(*dns01)
+		}
 
 		// Help configurations from older quickstarts.
-		if acme.IssuerDomainName == "" && acme.DirectoryURL == "https://acme-v02.api.letsencrypt.org/directory" {
-			acme.IssuerDomainName = "letsencrypt.org"
+		if acme.IssuerDomainName == "" {
+			switch acme.DirectoryURL {
+			case "https://acme-v02.api.letsencrypt.org/directory":
+				acme.IssuerDomainName = "letsencrypt.org"
+			case "https://acme.zerossl.com/v2/DV90":
+				acme.IssuerDomainName = "sectigo.com"
+			case "https://api.buypass.com/acme/directory":
+				acme.IssuerDomainName = "buypass.com"
+			}
 		}
 
 		c.ACME[name] = acme
 	}
 
+	// Hosts with a specific ACME provider get their certificates from that provider,
+	// on all listeners.
+	acmeHosts := map[dns.Domain]string{}
+	routes := map[string]*autotls.Manager{}
+	for name, acme := range c.ACME {
+		for _, d := range acme.HostDomains {
+			if other, ok := acmeHosts[d]; ok {
+				addErrorf("host %s listed for multiple acme providers: %q and %q", d, other, name)
+			}
+			acmeHosts[d] = name
+			if acme.Manager != nil {
+				routes[d.ASCII] = acme.Manager
+			}
+		}
+	}
+	for _, acme := range c.ACME {
+		if acme.Manager == nil {
+			continue
+		}
+		var fallbacks []*autotls.Manager
+		for _, fb := range acme.Fallback {
+			if m := c.ACME[fb].Manager; m != nil {
+				fallbacks = append(fallbacks, m)
+			}
+		}
+		acme.Manager.SetFallbacks(fallbacks)
+		acme.Manager.SetRoutes(routes)
+	}
+
 	var haveUnspecifiedSMTPListener bool
 	for name, l := range c.Listeners {
 		if l.Hostname != "" {
@@ -769,11 +1441,81 @@ func PrepareStaticConfig(ctx context.Context, log mlog.Log, configFile string, c
 				}
 				minVersion = v
 			}
+			var cipherSuites []uint16
+			if len(l.TLS.CipherSuites) > 0 {
+				suites := map[string]uint16{}
+				tls13Suites := map[string]bool{}
+				for _, cs := range tls.CipherSuites() {
+					suites[cs.Name] = cs.ID
+					if slices.Equal(cs.SupportedVersions, []uint16{tls.VersionTLS13}) {
+						tls13Suites[cs.Name] = true
+					}
+				}
+				for _, suite := range l.TLS.CipherSuites {
+					id, ok := suites[suite]
+					if tls13Suites[suite] {
+						addErrorf("listener %q: TLS cipher suite %q is for TLS 1.3, whose cipher suites cannot be configured", name, suite)
+						continue
+					} else if !ok {
+						addErrorf("listener %q: unknown or insecure TLS cipher suite %q", name, suite)
+						continue
+					}
+					cipherSuites = append(cipherSuites, id)
+				}
+			}
+			if l.TLS.OCSPStapling && len(l.TLS.KeyCerts) == 0 {
+				addErrorf("listener %q: OCSP stapling is only possible for static key/certificates", name)
+			}
+			// Only used for TLS 1.3 connections, older versions continue to work without ECH.
+			var echKeys []tls.EncryptedClientHelloKey
+			for i, f := range l.TLS.ECHKeyFiles {
+				if !doLoadTLSKeyCerts {
+					break
+				}
+				buf, err := readFilePrivileged(configDirPath(configFile, f))
+				if err != nil {
+					addErrorf("listener %q: reading ech key file: %v", name, err)
+					continue
+				}
+				key, err := ParseECHKey(buf)
+				if err != nil {
+					addErrorf("listener %q: parsing ech key file %q: %v", name, f, err)
+					continue
+				}
+				key.SendAsRetry = i == 0
+				echKeys = append(echKeys, key)
+			}
 			if l.TLS.Config != nil {
 				l.TLS.Config.MinVersion = minVersion
+				l.TLS.Config.CipherSuites = cipherSuites
+				l.TLS.Config.EncryptedClientHelloKeys = echKeys
 			}
 			if l.TLS.ACMEConfig != nil {
 				l.TLS.ACMEConfig.MinVersion = minVersion
+				l.TLS.ACMEConfig.CipherSuites = cipherSuites
+				l.TLS.ACMEConfig.EncryptedClientHelloKeys = echKeys
+			}
+			if ca := l.TLS.ClientAuth; ca != nil {
+				if len(ca.CAFiles) == 0 {
+					addErrorf("listener %q: client authentication requires at least one CA file", name)
+				}
+				ca.CAPool = x509.NewCertPool()
+				for _, f := range ca.CAFiles {
+					p := configDirPath(configFile, f)
+					buf, err := os.ReadFile(p)
+					if err != nil {
+						addErrorf("listener %q: reading client authentication CA file: %v", name, err)
+					} else if !ca.CAPool.AppendCertsFromPEM(buf) {
+						addErrorf("listener %q: no CA certificates in client authentication CA file %q", name, f)
+					}
+				}
+				if l.TLS.Config != nil {
+					// Only IMAP and SMTP submission request client certificates. Clients that don't
+					// send a certificate can still authenticate with a password.
+					l.TLS.ClientAuthConfig = l.TLS.Config.Clone()
+					l.TLS.ClientAuthConfig.ClientAuth = tls.VerifyClientCertIfGiven
+					l.TLS.ClientAuthConfig.ClientCAs = ca.CAPool
+				}
 			}
 		} else {
 			var needsTLS []string
@@ -790,6 +1532,7 @@ func PrepareStaticConfig(ctx context.Context, log mlog.Log, configFile string, c
 			needtls("AdminHTTPS", l.AdminHTTPS.Enabled)
 			needtls("AutoconfigHTTPS", l.AutoconfigHTTPS.Enabled && !l.AutoconfigHTTPS.NonTLS)
 			needtls("MTASTSHTTPS", l.MTASTSHTTPS.Enabled && !l.MTASTSHTTPS.NonTLS)
+			needtls("WKDHTTPS", l.WKDHTTPS.Enabled && !l.WKDHTTPS.NonTLS)
 			needtls("WebserverHTTPS", l.WebserverHTTPS.Enabled)
 			if len(needsTLS) > 0 {
 				addErrorf("listener %q does not specify tls config, but requires tls for %s", name, strings.Join(needsTLS, ", "))
@@ -891,10 +1634,10 @@ func PrepareStaticConfig(ctx context.Context, log mlog.Log, configFile string, c
 		}
 
 		if isTLS && t.STARTTLSInsecureSkipVerify {
-			addErrorf("transport %s: cannot have STARTTLSInsecureSkipVerify with immediate TLS")
+			addErrorf("transport %s: cannot have STARTTLSInsecureSkipVerify with immediate TLS", name)
 		}
 		if isTLS && t.NoSTARTTLS {
-			addErrorf("transport %s: cannot have NoSTARTTLS with immediate TLS")
+			addErrorf("transport %s: cannot have NoSTARTTLS with immediate TLS", name)
 		}
 
 		if t.Auth == nil {
@@ -907,6 +1650,8 @@ func PrepareStaticConfig(ctx context.Context, log mlog.Log, configFile string, c
 			}
 			seen[m] = true
 			switch m {
+			case "SCRAM-SHA-512-PLUS":
+			case "SCRAM-SHA-512":
 			case "SCRAM-SHA-256-PLUS":
 			case "SCRAM-SHA-256":
 			case "SCRAM-SHA-1-PLUS":
@@ -920,7 +1665,7 @@ func PrepareStaticConfig(ctx context.Context, log mlog.Log, configFile string, c
 
 		t.Auth.EffectiveMechanisms = t.Auth.Mechanisms
 		if len(t.Auth.EffectiveMechanisms) == 0 {
-			t.Auth.EffectiveMechanisms = []string{"SCRAM-SHA-256-PLUS", "SCRAM-SHA-256", "SCRAM-SHA-1-PLUS", "SCRAM-SHA-1", "CRAM-MD5"}
+			t.Auth.EffectiveMechanisms = []string{"SCRAM-SHA-512-PLUS", "SCRAM-SHA-512", "SCRAM-SHA-256-PLUS", "SCRAM-SHA-256", "SCRAM-SHA-1-PLUS", "SCRAM-SHA-1", "CRAM-MD5"}
 		}
 	}
 
@@ -1015,17 +1760,24 @@ func ParseDynamicConfig(ctx context.Context, log mlog.Log, dynamicPath string, s
 		errs = append(errs, fmt.Errorf(format, args...))
 	}
 
-	f, err := os.Open(dynamicPath)
+	// Changes to included files are only noticed when domains.conf itself changes.
+	fi, err := os.Stat(dynamicPath)
+	if err != nil {
+		addErrorf("stat domains config: %v", err)
+		return
+	}
+	cf, err := readConfigFile(dynamicPath)
 	if err != nil {
 		addErrorf("parsing domains config: %v", err)
 		return
 	}
-	defer f.Close()
-	fi, err := f.Stat()
+	// Older versions are migrated in memory, "mox serve" writes the migrated file.
+	buf, _, err := migrateDynamic(cf.buf)
 	if err != nil {
-		addErrorf("stat domains config: %v", err)
+		addErrorf("migrating domains config: %v", err)
+		return
 	}
-	if err := sconf.Parse(f, &c); err != nil {
+	if err := sconf.Parse(bytes.NewReader(buf), &c); err != nil {
 		addErrorf("parsing dynamic config file: %v", err)
 		return
 	}
@@ -1119,6 +1871,30 @@ func prepareDynamicConfig(ctx context.Context, log mlog.Log, dynamicPath string,
 
 	checkRoutes("global routes", c.Routes)
 
+	checkFooter := func(descr string, f *config.Footer) {
+		if f != nil && len(f.Text) == 0 && len(f.HTML) == 0 {
+			addErrorf("%s: footer must have text or html", descr)
+		}
+	}
+
+	checkAddressRewrites := func(descr string, l []config.AddressRewrite) {
+		for i, ar := range l {
+			re, err := regexp.Compile("(?i)" + ar.AddressRegexp)
+			if err != nil {
+				addErrorf("%s: compiling address regexp %q: %v", descr, ar.AddressRegexp, err)
+			}
+			l[i].Address = re
+			if ar.Replace == "" {
+				addErrorf("%s: address rewrite for %q must have a replacement", descr, ar.AddressRegexp)
+			}
+			if !ar.Sender && !ar.Recipient {
+				addErrorf("%s: address rewrite for %q must apply to sender and/or recipient addresses", descr, ar.AddressRegexp)
+			}
+		}
+	}
+
+	checkAddressRewrites("global address rewrites", c.AddressRewrites)
+
 	// Validate domains.
 	for d, domain := range c.Domains {
 		dnsdomain, err := dns.ParseDomain(d)
@@ -1138,6 +1914,75 @@ func prepareDynamicConfig(ctx context.Context, log mlog.Log, dynamicPath string,
 			domain.ClientSettingsDNSDomain = csd
 		}
 
+		for _, a := range domain.Admins {
+			if _, err := smtp.ParseAddress(a); err != nil {
+				addErrorf("domain %s: bad admin address %q: %s", d, a, err)
+			}
+		}
+
+		domain.SenderActions = nil
+		addSenders := func(l []string, action string) {
+			for _, s := range l {
+				sender, err := ParseSender(s)
+				if err != nil {
+					addErrorf("domain %s: bad sender %q for %s list: %s", d, s, action, err)
+					continue
+				}
+				if domain.SenderActions == nil {
+					domain.SenderActions = map[string]string{}
+				}
+				if prev, ok := domain.SenderActions[sender]; ok && prev == action {
+					addErrorf("domain %s: duplicate sender %q in %s list", d, s, action)
+					continue
+				} else if ok {
+					addErrorf("domain %s: sender %q in both %s and %s list", d, s, prev, action)
+					continue
+				}
+				domain.SenderActions[sender] = action
+			}
+		}
+		addSenders(domain.SenderAllow, "allow")
+		addSenders(domain.SenderReject, "reject")
+		addSenders(domain.SenderJunk, "junk")
+
+		if a := domain.Auth; a != nil {
+			if (a.LDAP == nil) == (a.PAM == nil) {
+				addErrorf("domain %s: auth must have exactly one of LDAP and PAM", d)
+			}
+			if l := a.LDAP; l != nil {
+				if u, err := url.Parse(l.URL); err != nil {
+					addErrorf("domain %s: parsing ldap url: %v", d, err)
+				} else if u.Scheme != "ldap" && u.Scheme != "ldaps" {
+					addErrorf("domain %s: ldap url must have scheme ldap or ldaps", d)
+				} else if u.Hostname() == "" {
+					addErrorf("domain %s: ldap url must have a host", d)
+				} else if l.StartTLS && u.Scheme != "ldap" {
+					addErrorf("domain %s: starttls is only possible with scheme ldap", d)
+				} else if ip := net.ParseIP(u.Hostname()); u.Scheme == "ldap" && !l.StartTLS && u.Hostname() != "localhost" && (ip == nil || !ip.IsLoopback()) {
+					// Passwords would be sent in plain text over the network.
+					addErrorf("domain %s: ldap url without starttls is only allowed for loopback hosts, use ldaps or starttls", d)
+				}
+				if l.UserDNTemplate != "" {
+					if !strings.Contains(l.UserDNTemplate, "%s") {
+						addErrorf("domain %s: ldap user dn template must contain %%s", d)
+					}
+					if l.BaseDN != "" || l.Filter != "" {
+						addErrorf("domain %s: ldap user dn template cannot be combined with base dn and filter", d)
+					}
+				} else if l.Filter == "" || !strings.Contains(l.Filter, "%s") {
+					addErrorf("domain %s: ldap requires a filter containing %%s, or a user dn template", d)
+				} else if err := extauth.LDAPFilterCheck(l.Filter); err != nil {
+					addErrorf("domain %s: %v", d, err)
+				}
+				if l.BindPassword != "" && l.BindDN == "" {
+					addErrorf("domain %s: ldap bind password requires bind dn", d)
+				}
+			}
+			if a.PAM != nil && !extauth.PAMSupported {
+				addErrorf("domain %s: %v", d, extauth.ErrPAMUnsupported)
+			}
+		}
+
 		for _, sign := range domain.DKIM.Sign {
 			if _, ok := domain.DKIM.Selectors[sign]; !ok {
 				addErrorf("selector %s for signing is missing in domain %s", sign, d)
@@ -1245,9 +2090,14 @@ func prepareDynamicConfig(ctx context.Context, log mlog.Log, dynamicPath string,
 			default:
 				addErrorf("invalid mtasts mode %q", sts.Mode)
 			}
+			if sts.EnforceAfter < 0 {
+				addErrorf("invalid negative mtasts EnforceAfter %v", sts.EnforceAfter)
+			}
 		}
 
 		checkRoutes("routes for domain", domain.Routes)
+		checkFooter("domain "+d, domain.Footer)
+		checkAddressRewrites("address rewrites for domain "+d, domain.AddressRewrites)
 
 		c.Domains[d] = domain
 	}
@@ -1290,6 +2140,10 @@ func prepareDynamicConfig(ctx context.Context, log mlog.Log, dynamicPath string,
 			acc.NotJunkMailbox = r
 		}
 
+		if acc.JunkFilter != nil && (acc.JunkFilter.SharedWeight < 0 || acc.JunkFilter.SharedWeight > 1) {
+			addErrorf("account %q: junk filter SharedWeight must be between 0 and 1", accName)
+		}
+
 		acc.ParsedFromIDLoginAddresses = make([]smtp.Address, len(acc.FromIDLoginAddresses))
 		for i, s := range acc.FromIDLoginAddresses {
 			a, err := smtp.ParseAddress(s)
@@ -1509,6 +2363,13 @@ func prepareDynamicConfig(ctx context.Context, log mlog.Log, dynamicPath string,
 		}
 
 		checkRoutes("routes for account", acc.Routes)
+		checkFooter("account "+accName, acc.Footer)
+
+		if acc.PasswordPolicy != nil {
+			if err := checkPasswordPolicy(*acc.PasswordPolicy); err != nil {
+				addErrorf("password policy for account %q: %v", accName, err)
+			}
+		}
 	}
 
 	// Set DMARC destinations.
@@ -1629,12 +2490,19 @@ func prepareDynamicConfig(ctx context.Context, log mlog.Log, dynamicPath string,
 				addErrorf("domain %q: alias %q already present as regular address", d, addr)
 				continue
 			}
+			if a.Owner != "" {
+				if _, ok := c.Accounts[a.Owner]; !ok {
+					addErrorf("domain %q: alias %q has owner %q that does not exist", d, addr, a.Owner)
+					continue
+				}
+			}
 			if len(a.Addresses) == 0 {
 				// Not currently possible, Addresses isn't optional.
 				addErrorf("domain %q: alias %q needs at least one destination address", d, addr)
 				continue
 			}
 			a.ParsedAddresses = make([]config.AliasAddress, 0, len(a.Addresses))
+			a.ExternalAddresses = nil
 			seen := map[string]bool{}
 			for _, destAddr := range a.Addresses {
 				da, err := smtp.ParseAddress(destAddr)
@@ -1643,19 +2511,41 @@ func prepareDynamicConfig(ctx context.Context, log mlog.Log, dynamicPath string,
 					continue
 				}
 				dastr := da.Pack(true)
-				accDest, ok := accDests[dastr]
-				if !ok {
-					addErrorf("domain %q: alias %q references non-existent address %q", d, addr, destAddr)
-					continue
-				}
 				if seen[dastr] {
 					addErrorf("domain %q: alias %q has duplicate address %q", d, addr, destAddr)
 					continue
 				}
 				seen[dastr] = true
+				if _, ok := c.Domains[da.Domain.Name()]; !ok {
+					a.ExternalAddresses = append(a.ExternalAddresses, da)
+					continue
+				}
+				accDest, ok := accDests[dastr]
+				if !ok {
+					addErrorf("domain %q: alias %q references non-existent address %q", d, addr, destAddr)
+					continue
+				}
 				aa := config.AliasAddress{Address: da, AccountName: accDest.Account, Destination: accDest.Destination}
 				a.ParsedAddresses = append(a.ParsedAddresses, aa)
 			}
+			if len(a.ExternalAddresses) > 0 && len(a.ParsedAddresses) == 0 {
+				addErrorf("domain %q: alias %q with external addresses needs at least one local address", d, addr)
+				continue
+			}
+			a.ParsedOwner = config.AliasAddress{}
+			if a.OwnerAddress != "" {
+				oa, err := smtp.ParseAddress(a.OwnerAddress)
+				if err != nil {
+					addErrorf("domain %q: parsing owner address %q of alias %q: %v", d, a.OwnerAddress, addr, err)
+					continue
+				}
+				accDest, ok := accDests[oa.Pack(true)]
+				if !ok {
+					addErrorf("domain %q: alias %q has owner address %q that is not a local address", d, addr, a.OwnerAddress)
+					continue
+				}
+				a.ParsedOwner = config.AliasAddress{Address: oa, AccountName: accDest.Account, Destination: accDest.Destination}
+			}
 			a.Domain = domain.Domain
 			c.Domains[d].Aliases[lpstr] = a
 			aliases[addr] = a
@@ -1664,9 +2554,12 @@ func prepareDynamicConfig(ctx context.Context, log mlog.Log, dynamicPath string,
 				acc := c.Accounts[aa.AccountName]
 				var addrs []string
 				if a.ListMembers {
-					addrs = make([]string, len(a.ParsedAddresses))
-					for i := range a.ParsedAddresses {
-						addrs[i] = a.ParsedAddresses[i].Address.Pack(true)
+					addrs = make([]string, 0, len(a.ParsedAddresses)+len(a.ExternalAddresses))
+					for _, maa := range a.ParsedAddresses {
+						addrs = append(addrs, maa.Address.Pack(true))
+					}
+					for _, ea := range a.ExternalAddresses {
+						addrs = append(addrs, ea.Pack(true))
 					}
 				}
 				// Keep the non-sensitive fields.
@@ -1674,6 +2567,7 @@ func prepareDynamicConfig(ctx context.Context, log mlog.Log, dynamicPath string,
 					PostPublic:   a.PostPublic,
 					ListMembers:  a.ListMembers,
 					AllowMsgFrom: a.AllowMsgFrom,
+					Owner:        a.Owner,
 					LocalpartStr: a.LocalpartStr,
 					Domain:       a.Domain,
 				}
@@ -1683,6 +2577,38 @@ func prepareDynamicConfig(ctx context.Context, log mlog.Log, dynamicPath string,
 		}
 	}
 
+	// Spamtraps, per domain. Must not overlap with regular addresses and aliases.
+	for d, domain := range c.Domains {
+		domain.SpamtrapLocalparts = nil
+		for _, lpstr := range domain.Spamtraps {
+			lp, err := smtp.ParseLocalpart(lpstr)
+			if err != nil {
+				addErrorf("domain %q: parsing localpart %q for spamtrap: %v", d, lpstr, err)
+				continue
+			} else if domain.LocalpartCatchallSeparator != "" && strings.Contains(string(lp), domain.LocalpartCatchallSeparator) {
+				addErrorf("domain %q: spamtrap %q contains localpart catchall separator", d, lpstr)
+				continue
+			}
+			clp := CanonicalLocalpart(lp, domain)
+			addr := smtp.NewAddress(clp, domain.Domain).Pack(true)
+			if _, ok := domain.SpamtrapLocalparts[clp]; ok {
+				addErrorf("domain %q: duplicate spamtrap address %q", d, addr)
+				continue
+			} else if _, ok := accDests[addr]; ok {
+				addErrorf("domain %q: spamtrap %q already present as regular address", d, addr)
+				continue
+			} else if _, ok := aliases[addr]; ok {
+				addErrorf("domain %q: spamtrap %q already present as alias", d, addr)
+				continue
+			}
+			if domain.SpamtrapLocalparts == nil {
+				domain.SpamtrapLocalparts = map[smtp.Localpart]struct{}{}
+			}
+			domain.SpamtrapLocalparts[clp] = struct{}{}
+		}
+		c.Domains[d] = domain
+	}
+
 	// Check webserver configs.
 	if (len(c.WebDomainRedirects) > 0 || len(c.WebHandlers) > 0) && !haveWebserverListener {
 		addErrorf("WebDomainRedirects or WebHandlers configured but no listener with WebserverHTTP or WebserverHTTPS enabled")
@@ -1731,6 +2657,87 @@ func prepareDynamicConfig(ctx context.Context, log mlog.Log, dynamicPath string,
 		}
 		wh.Path = re
 
+		if wa := wh.Access; wa != nil {
+			wa.IPAllowNets = nil
+			wa.IPDenyNets = nil
+			for _, s := range wa.IPAllow {
+				if ipnet, err := parseIPNet(s); err != nil {
+					addErrorf("webhandler %s %s: access: parsing allowed ip: %v", wh.Domain, wh.PathRegexp, err)
+				} else {
+					wa.IPAllowNets = append(wa.IPAllowNets, ipnet)
+				}
+			}
+			for _, s := range wa.IPDeny {
+				if ipnet, err := parseIPNet(s); err != nil {
+					addErrorf("webhandler %s %s: access: parsing denied ip: %v", wh.Domain, wh.PathRegexp, err)
+				} else {
+					wa.IPDenyNets = append(wa.IPDenyNets, ipnet)
+				}
+			}
+			if wa.BasicAuth != nil && wa.OIDC != nil {
+				addErrorf("webhandler %s %s: access: at most one of basic auth and oidc can be set", wh.Domain, wh.PathRegexp)
+			}
+			if ba := wa.BasicAuth; ba != nil {
+				if !ba.AllAccounts && len(ba.Accounts) == 0 && ba.HTPasswdFile == "" {
+					addErrorf("webhandler %s %s: access: basic auth requires accounts or htpasswd file", wh.Domain, wh.PathRegexp)
+				}
+				for _, name := range ba.Accounts {
+					if _, ok := c.Accounts[name]; !ok {
+						addErrorf("webhandler %s %s: access: basic auth: unknown account %q", wh.Domain, wh.PathRegexp, name)
+					}
+				}
+				ba.HTPasswdPath = ""
+				if ba.HTPasswdFile != "" {
+					ba.HTPasswdPath = configDirPath(dynamicPath, ba.HTPasswdFile)
+					if _, err := os.Stat(ba.HTPasswdPath); err != nil {
+						addErrorf("webhandler %s %s: access: basic auth: htpasswd file: %v", wh.Domain, wh.PathRegexp, err)
+					}
+				}
+			}
+			if wo := wa.OIDC; wo != nil {
+				if static.OIDC == nil {
+					addErrorf("webhandler %s %s: access: oidc requires oidc in mox.conf", wh.Domain, wh.PathRegexp)
+				}
+				for _, s := range wo.Emails {
+					if _, err := smtp.ParseAddress(s); err != nil {
+						addErrorf("webhandler %s %s: access: oidc: parsing email address %q: %v", wh.Domain, wh.PathRegexp, s, err)
+					}
+				}
+				for _, s := range wo.Domains {
+					if _, err := dns.ParseDomain(s); err != nil {
+						addErrorf("webhandler %s %s: access: oidc: parsing domain %q: %v", wh.Domain, wh.PathRegexp, s, err)
+					}
+				}
+			}
+		}
+
+		if rl := wh.RateLimit; rl != nil && (rl.Requests <= 0 || rl.Window < 0) {
+			addErrorf("webhandler %s %s: rate limit: requests must be positive and window must not be negative", wh.Domain, wh.PathRegexp)
+		}
+		for j := range wh.Rules {
+			rule := &wh.Rules[j]
+			if rule.PathRegexp != "" {
+				rule.Path, err = regexp.Compile(rule.PathRegexp)
+				if err != nil {
+					addErrorf("webhandler %s %s: rule %d: compiling path regexp: %v", wh.Domain, wh.PathRegexp, j+1, err)
+				}
+			}
+			if rule.UserAgentRegexp != "" {
+				rule.UserAgent, err = regexp.Compile("(?i)" + rule.UserAgentRegexp)
+				if err != nil {
+					addErrorf("webhandler %s %s: rule %d: compiling user-agent regexp: %v", wh.Domain, wh.PathRegexp, j+1, err)
+				}
+			}
+			for _, m := range rule.Methods {
+				if m == "" || strings.ContainsAny(m, " \t") {
+					addErrorf("webhandler %s %s: rule %d: invalid method %q", wh.Domain, wh.PathRegexp, j+1, m)
+				}
+			}
+			if rule.MaxBodySize < 0 {
+				addErrorf("webhandler %s %s: rule %d: max body size must not be negative", wh.Domain, wh.PathRegexp, j+1)
+			}
+		}
+
 		var n int
 		if wh.WebStatic != nil {
 			n++
@@ -1758,7 +2765,7 @@ func prepareDynamicConfig(ctx context.Context, log mlog.Log, dynamicPath string,
 				case "", "/":
 					u.Path = "/"
 				default:
-					addErrorf("webredirect %s %s: BaseURL must have empty path", wh.Domain, wh.PathRegexp, wr.BaseURL)
+					addErrorf("webredirect %s %s: BaseURL %s must have empty path", wh.Domain, wh.PathRegexp, wr.BaseURL)
 				}
 				wr.URL = u
 			}
@@ -1786,13 +2793,39 @@ func prepareDynamicConfig(ctx context.Context, log mlog.Log, dynamicPath string,
 			}
 			wf.TargetURL = u
 
+			checkHeader := func(k string) {
+				if strings.TrimSpace(k) != k || k == "" {
+					addErrorf("webforward %s %s: bad header %q", wh.Domain, wh.PathRegexp, k)
+				}
+			}
 			for k := range wf.ResponseHeaders {
-				xk := k
-				k := strings.TrimSpace(xk)
-				if k != xk || k == "" {
-					addErrorf("webforward %s %s: bad header %q", wh.Domain, wh.PathRegexp, xk)
+				checkHeader(k)
+			}
+			for k := range wf.RequestHeaders {
+				checkHeader(k)
+			}
+			for _, k := range wf.RequestHeadersRemove {
+				checkHeader(k)
+			}
+			for _, k := range wf.ResponseHeadersRemove {
+				checkHeader(k)
+			}
+			for _, l := range [][]config.WebHeaderRewrite{wf.RequestHeaderRewrites, wf.ResponseHeaderRewrites} {
+				for i, hr := range l {
+					checkHeader(hr.Header)
+					re, err := regexp.Compile(hr.ValueRegexp)
+					if err != nil {
+						addErrorf("webforward %s %s: compiling header rewrite regexp %q: %v", wh.Domain, wh.PathRegexp, hr.ValueRegexp, err)
+					}
+					l[i].Value = re
 				}
 			}
+			if wf.HTTP2 && u != nil && u.Scheme != "http" && u.Scheme != "https" {
+				addErrorf("webforward %s %s: http2 requires url with scheme http or https", wh.Domain, wh.PathRegexp)
+			}
+			if wf.DialTimeout < 0 || wf.ResponseTimeout < 0 {
+				addErrorf("webforward %s %s: timeouts must not be negative", wh.Domain, wh.PathRegexp)
+			}
 		}
 		if n != 1 {
 			addErrorf("webhandler %s %s: must have exactly one handler, not %d", wh.Domain, wh.PathRegexp, n)
@@ -1859,6 +2892,11 @@ func loadTLSKeyCerts(configFile, kind string, ctls *config.TLS) error {
 	ctls.Config = &tls.Config{
 		Certificates: certs,
 	}
+	if ctls.OCSPStapling {
+		// Certificates with staples are replaced by the stapler while serving.
+		ctls.OCSPStapler = ocsp.NewStapler(kind, certs)
+		ctls.Config.GetCertificate = ctls.OCSPStapler.GetCertificate
+	}
 	return nil
 }
 
@@ -0,0 +1,138 @@
+package mox
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Maximum nesting of includes in config files, to catch include loops.
+const configIncludeDepth = 10
+
+// configFile is the contents of a config file after processing includes,
+// environment variables and file references.
+type configFile struct {
+	buf  []byte // Fully processed, for parsing.
+	text []byte // Only includes processed, without secrets, for showing changes.
+}
+
+var configEnvVar = regexp.MustCompile(`\$\$\{|\$\{[A-Za-z_][A-Za-z0-9_]*\}`)
+
+// readConfigFile reads config file p, processing includes, environment variables
+// and file references, so config files can be stored in version control without
+// secrets, and shared between environments:
+//
+//   - A line "!include path", possibly indented, is replaced by the lines of the
+//     file at path, with the indenting of the include line added.
+//   - "${NAME}" is replaced with environment variable NAME, which must be set.
+//     "$${" is replaced with a literal "${".
+//   - A value "!file:path", e.g. for a password, is replaced by the contents of the
+//     file at path, without trailing newline. The file must have a single line.
+//
+// Relative paths are relative to the directory of the file with the line.
+func readConfigFile(p string) (configFile, error) {
+	var buf, text bytes.Buffer
+	if err := readConfigLines(&buf, &text, p, "", 0); err != nil {
+		return configFile{}, err
+	}
+	return configFile{buf.Bytes(), text.Bytes()}, nil
+}
+
+func readConfigLines(buf, text *bytes.Buffer, p, indent string, depth int) error {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return fmt.Errorf("read config file: %w", err)
+	}
+	dir := filepath.Dir(p)
+	path := func(s string) string {
+		if filepath.IsAbs(s) {
+			return s
+		}
+		return filepath.Join(dir, s)
+	}
+
+	lines := strings.Split(string(data), "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	for i, line := range lines {
+		line = strings.TrimSuffix(line, "\r")
+		t := strings.TrimLeft(line, "\t")
+		if strings.HasPrefix(t, "#") {
+			buf.WriteString(indent + line + "\n")
+			text.WriteString(indent + line + "\n")
+			continue
+		}
+
+		var xerr error
+		expanded := configEnvVar.ReplaceAllStringFunc(line, func(s string) string {
+			if s == "$${" {
+				return "${"
+			}
+			name := s[2 : len(s)-1]
+			v, ok := os.LookupEnv(name)
+			if !ok && xerr == nil {
+				xerr = fmt.Errorf("%s:%d: environment variable %s not set", p, i+1, name)
+			}
+			return v
+		})
+		if xerr != nil {
+			return xerr
+		}
+
+		if s, ok := strings.CutPrefix(strings.TrimLeft(expanded, "\t"), "!include "); ok {
+			if depth >= configIncludeDepth {
+				return fmt.Errorf("%s:%d: includes nested too deep", p, i+1)
+			}
+			lineIndent := line[:len(line)-len(t)]
+			if err := readConfigLines(buf, text, path(strings.TrimSpace(s)), indent+lineIndent, depth+1); err != nil {
+				return fmt.Errorf("%s:%d: include: %w", p, i+1, err)
+			}
+			continue
+		}
+		text.WriteString(indent + line + "\n")
+
+		// Values are either after "key: ", or after "- " for list elements.
+		xt := strings.TrimLeft(expanded, "\t")
+		var value string
+		if v, ok := strings.CutPrefix(xt, "- "); ok {
+			value = v
+		} else if _, v, ok := strings.Cut(xt, ": "); ok {
+			value = v
+		}
+		if fp, ok := strings.CutPrefix(value, "!file:"); ok {
+			secret, err := os.ReadFile(path(fp))
+			if err != nil {
+				return fmt.Errorf("%s:%d: read file with value: %v", p, i+1, err)
+			}
+			s := strings.TrimSuffix(strings.TrimSuffix(string(secret), "\n"), "\r")
+			if strings.Contains(s, "\n") {
+				return fmt.Errorf("%s:%d: file %s with value must have a single line", p, i+1, fp)
+			}
+			expanded = expanded[:len(expanded)-len(value)] + s
+		}
+		buf.WriteString(indent + expanded + "\n")
+	}
+	return nil
+}
+
+// configFileProcessed returns whether the config file at p uses includes,
+// environment variables or file references.
+func configFileProcessed(p string) (bool, error) {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return false, err
+	}
+	cf, err := readConfigFile(p)
+	if err != nil {
+		return false, err
+	}
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	if len(data) > 0 && !bytes.HasSuffix(data, []byte("\n")) {
+		data = append(data, '\n')
+	}
+	return !bytes.Equal(data, cf.buf), nil
+}
@@ -0,0 +1,100 @@
+package mox
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name, s string) string {
+		t.Helper()
+		p := filepath.Join(dir, name)
+		err := os.MkdirAll(filepath.Dir(p), 0770)
+		if err == nil {
+			err = os.WriteFile(p, []byte(s), 0660)
+		}
+		if err != nil {
+			t.Fatalf("write file: %v", err)
+		}
+		return p
+	}
+
+	t.Setenv("MOXTEST_HOST", "mail.mox.example")
+	t.Setenv("MOXTEST_DIR", "sub")
+
+	writeFile("secret", "s3cret\n")
+	writeFile("sub/transports.conf", `submission:
+	Submission:
+		Host: smtp.mox.example
+		Auth:
+			Password: !file:../secret
+`)
+	p := writeFile("mox.conf", `# Comment with ${UNSET} is left alone.
+Hostname: ${MOXTEST_HOST}
+Path: /$${1}
+Transports:
+	!include ${MOXTEST_DIR}/transports.conf
+List:
+	- !file:secret
+`)
+
+	cf, err := readConfigFile(p)
+	if err != nil {
+		t.Fatalf("read config file: %v", err)
+	}
+	exp := `# Comment with ${UNSET} is left alone.
+Hostname: mail.mox.example
+Path: /${1}
+Transports:
+	submission:
+		Submission:
+			Host: smtp.mox.example
+			Auth:
+				Password: s3cret
+List:
+	- s3cret
+`
+	if string(cf.buf) != exp {
+		t.Fatalf("got:\n%s\nexpected:\n%s", cf.buf, exp)
+	}
+	expText := `# Comment with ${UNSET} is left alone.
+Hostname: ${MOXTEST_HOST}
+Path: /$${1}
+Transports:
+	submission:
+		Submission:
+			Host: smtp.mox.example
+			Auth:
+				Password: !file:../secret
+List:
+	- !file:secret
+`
+	if string(cf.text) != expText {
+		t.Fatalf("got text:\n%s\nexpected:\n%s", cf.text, expText)
+	}
+	if processed, err := configFileProcessed(p); err != nil || !processed {
+		t.Fatalf("config file processed: got %v, %v, expected true", processed, err)
+	}
+
+	plain := writeFile("plain.conf", "Hostname: mail.mox.example\n")
+	if processed, err := configFileProcessed(plain); err != nil || processed {
+		t.Fatalf("config file processed: got %v, %v, expected false", processed, err)
+	}
+
+	testErr := func(s, expErr string) {
+		t.Helper()
+		p := writeFile("bad.conf", s)
+		_, err := readConfigFile(p)
+		if err == nil || !strings.Contains(err.Error(), expErr) {
+			t.Fatalf("got err %v, expected error with %q", err, expErr)
+		}
+	}
+	testErr("Hostname: ${MOXTEST_UNSET}\n", "environment variable MOXTEST_UNSET not set")
+	testErr("Password: !file:missing\n", "read file with value")
+	writeFile("multiline", "a\nb\n")
+	testErr("Password: !file:multiline\n", "must have a single line")
+	testErr("!include bad.conf\n", "includes nested too deep")
+}
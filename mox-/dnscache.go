@@ -0,0 +1,41 @@
+package mox
+
+import (
+	"time"
+
+	"github.com/mjl-/mox/dns"
+)
+
+// DNSCache holds DNS lookup results for message delivery, nil if not configured.
+// Set by InitDNSCache.
+var DNSCache *dns.Cache
+
+// InitDNSCache sets DNSCache based on the DNSCache config.
+func InitDNSCache() {
+	dc := Conf.Static.DNSCache
+	if dc == nil {
+		DNSCache = nil
+		return
+	}
+	ttl := dc.TTL
+	if ttl == 0 {
+		ttl = time.Minute
+	}
+	negativeTTL := dc.NegativeTTL
+	if negativeTTL == 0 {
+		negativeTTL = 30 * time.Second
+	}
+	maxEntries := dc.MaxEntries
+	if maxEntries == 0 {
+		maxEntries = 10000
+	}
+	DNSCache = dns.NewCache(ttl, negativeTTL, maxEntries)
+}
+
+// CachingResolver returns r, using DNSCache for lookups if configured.
+func CachingResolver(r dns.Resolver) dns.Resolver {
+	if DNSCache == nil {
+		return r
+	}
+	return DNSCache.Resolver(r)
+}
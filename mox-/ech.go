@@ -0,0 +1,163 @@
+package mox
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	cryptorand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// Encrypted Client Hello (ECH), see RFC draft-ietf-tls-esni. The ECHConfig
+// structure is also used in the "ech" parameter of DNS HTTPS records, which is how
+// clients find the configuration.
+
+const (
+	echConfigVersion = 0xfe0d // Draft version 13 and later.
+	echKEMX25519     = 0x0020 // DHKEM(X25519, HKDF-SHA256)
+	echKDFSHA256     = 0x0001 // HKDF-SHA256
+)
+
+// ECH cipher suites we announce: AES-128-GCM, AES-256-GCM, ChaCha20Poly1305.
+var echAEADs = []uint16{0x0001, 0x0002, 0x0003}
+
+// MakeECHKey generates a new X25519 key for Encrypted Client Hello, with an
+// ECHConfig for publicName, the name of the "client-facing" server that clients
+// connect to and that must have a valid certificate. The returned PEM data
+// contains the PKCS8 private key and the ECHConfigList. The latter is also
+// returned as is, for use in DNS HTTPS records.
+func MakeECHKey(publicName string, configID uint8) (pemBuf, configList []byte, err error) {
+	if publicName == "" || len(publicName) > 255 {
+		return nil, nil, fmt.Errorf("invalid public name %q", publicName)
+	}
+
+	privKey, err := ecdh.X25519().GenerateKey(cryptorand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("generating key: %w", err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(privKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("marshal key: %w", err)
+	}
+
+	config := echConfig(configID, privKey.PublicKey().Bytes(), publicName)
+	configList = binary.BigEndian.AppendUint16(nil, uint16(len(config)))
+	configList = append(configList, config...)
+
+	b := &bytes.Buffer{}
+	keyBlock := &pem.Block{
+		Type:    "PRIVATE KEY",
+		Headers: map[string]string{"Note": "x25519 encrypted client hello private key for " + publicName},
+		Bytes:   pkcs8,
+	}
+	configBlock := &pem.Block{
+		Type:  "ECHCONFIG",
+		Bytes: configList,
+	}
+	if err := pem.Encode(b, keyBlock); err != nil {
+		return nil, nil, fmt.Errorf("encoding pem: %w", err)
+	}
+	if err := pem.Encode(b, configBlock); err != nil {
+		return nil, nil, fmt.Errorf("encoding pem: %w", err)
+	}
+	return b.Bytes(), configList, nil
+}
+
+// echConfig returns a marshaled ECHConfig.
+func echConfig(configID uint8, publicKey []byte, publicName string) []byte {
+	var c []byte
+	c = append(c, configID)
+	c = binary.BigEndian.AppendUint16(c, echKEMX25519)
+	c = binary.BigEndian.AppendUint16(c, uint16(len(publicKey)))
+	c = append(c, publicKey...)
+	c = binary.BigEndian.AppendUint16(c, uint16(4*len(echAEADs)))
+	for _, aead := range echAEADs {
+		c = binary.BigEndian.AppendUint16(c, echKDFSHA256)
+		c = binary.BigEndian.AppendUint16(c, aead)
+	}
+	c = append(c, 0) // maximum_name_length, 0 is unknown.
+	c = append(c, uint8(len(publicName)))
+	c = append(c, publicName...)
+	c = binary.BigEndian.AppendUint16(c, 0) // No extensions.
+
+	buf := binary.BigEndian.AppendUint16(nil, echConfigVersion)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(c)))
+	return append(buf, c...)
+}
+
+// ParseECHKey parses a PEM file as generated by MakeECHKey, with an X25519 PKCS8
+// private key and an ECHCONFIG block holding an ECHConfigList. The first ECHConfig
+// in the list must be for the private key.
+func ParseECHKey(buf []byte) (tls.EncryptedClientHelloKey, error) {
+	var key tls.EncryptedClientHelloKey
+	var privKey *ecdh.PrivateKey
+	var configList []byte
+	for {
+		var block *pem.Block
+		block, buf = pem.Decode(buf)
+		if block == nil {
+			break
+		}
+		switch block.Type {
+		case "PRIVATE KEY":
+			k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return key, fmt.Errorf("parsing private key: %w", err)
+			}
+			ek, ok := k.(*ecdh.PrivateKey)
+			if !ok || ek.Curve() != ecdh.X25519() {
+				return key, fmt.Errorf("private key is %T, must be x25519", k)
+			}
+			privKey = ek
+		case "ECHCONFIG":
+			configList = block.Bytes
+		default:
+			return key, fmt.Errorf("unrecognized pem block %q", block.Type)
+		}
+	}
+	if privKey == nil {
+		return key, errors.New("missing private key")
+	}
+	if configList == nil {
+		return key, errors.New("missing ech config")
+	}
+
+	// We use the first config from the list.
+	if len(configList) < 2 || int(binary.BigEndian.Uint16(configList)) != len(configList)-2 {
+		return key, errors.New("malformed ech config list")
+	}
+	c := configList[2:]
+	if len(c) < 4 {
+		return key, errors.New("malformed ech config")
+	}
+	if v := binary.BigEndian.Uint16(c); v != echConfigVersion {
+		return key, fmt.Errorf("unsupported ech config version %#04x", v)
+	}
+	n := int(binary.BigEndian.Uint16(c[2:]))
+	if len(c) < 4+n {
+		return key, errors.New("truncated ech config")
+	}
+	config := c[:4+n]
+
+	// Check the config is for our key: config id (1), kem (2), public key length
+	// (2), public key.
+	contents := config[4:]
+	if len(contents) < 5 {
+		return key, errors.New("truncated ech config")
+	}
+	if kem := binary.BigEndian.Uint16(contents[1:]); kem != echKEMX25519 {
+		return key, fmt.Errorf("unsupported ech kem %#04x, must be x25519", kem)
+	}
+	pkLen := int(binary.BigEndian.Uint16(contents[3:]))
+	if len(contents) < 5+pkLen || !bytes.Equal(contents[5:5+pkLen], privKey.PublicKey().Bytes()) {
+		return key, errors.New("public key in ech config does not match private key")
+	}
+
+	key.Config = config
+	key.PrivateKey = privKey.Bytes()
+	return key, nil
+}
@@ -0,0 +1,92 @@
+package mox
+
+import (
+	"crypto/ed25519"
+	cryptorand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestECHKey(t *testing.T) {
+	tcheck := func(t *testing.T, err error, msg string) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("%s: %v", msg, err)
+		}
+	}
+
+	pemBuf, configList, err := MakeECHKey("mail.example", 1)
+	tcheck(t, err, "make ech key")
+
+	key, err := ParseECHKey(pemBuf)
+	tcheck(t, err, "parse ech key")
+	key.SendAsRetry = true
+
+	// Key must match the config.
+	otherBuf, _, err := MakeECHKey("mail.example", 1)
+	tcheck(t, err, "make ech key")
+	keyBlock, _ := pem.Decode(pemBuf)
+	_, rest := pem.Decode(otherBuf)
+	mixed := append(pem.EncodeToMemory(keyBlock), rest...)
+	if _, err := ParseECHKey(mixed); err == nil {
+		t.Fatalf("parsing ech key with mismatched config succeeded")
+	}
+	if _, err := ParseECHKey([]byte("bogus")); err == nil {
+		t.Fatalf("parsing bogus ech key succeeded")
+	}
+
+	// Do a handshake with ECH, with the inner hello for a different name than the
+	// public name.
+	privKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize)) // Fake key, don't use this for real!
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"mail.example", "secret.example"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certBuf, err := x509.CreateCertificate(cryptorand.Reader, template, template, privKey.Public(), privKey)
+	tcheck(t, err, "create certificate")
+	cert, err := x509.ParseCertificate(certBuf)
+	tcheck(t, err, "parse certificate")
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	serverConfig := &tls.Config{
+		Certificates:             []tls.Certificate{{Certificate: [][]byte{certBuf}, PrivateKey: privKey, Leaf: cert}},
+		EncryptedClientHelloKeys: []tls.EncryptedClientHelloKey{key},
+	}
+	var serverName string
+	serverConfig.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		serverName = hello.ServerName
+		return nil, nil
+	}
+	clientConfig := &tls.Config{
+		ServerName:                     "secret.example",
+		RootCAs:                        pool,
+		EncryptedClientHelloConfigList: configList,
+	}
+
+	sconn, cconn := net.Pipe()
+	defer sconn.Close()
+	defer cconn.Close()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- tls.Server(sconn, serverConfig).Handshake()
+	}()
+	tc := tls.Client(cconn, clientConfig)
+	err = tc.Handshake()
+	tcheck(t, err, "client handshake")
+	err = <-errc
+	tcheck(t, err, "server handshake")
+	if !tc.ConnectionState().ECHAccepted {
+		t.Fatalf("ech not accepted")
+	}
+	if serverName != "secret.example" {
+		t.Fatalf("server saw server name %q, expected secret.example", serverName)
+	}
+}
@@ -57,10 +57,12 @@ func ForkExecUnprivileged() {
 	// todo: see if we tie up child and root process so a kill -9 of the root process
 	// kills the child process too.
 	sigc := make(chan os.Signal, 1)
-	signal.Notify(sigc, os.Interrupt, syscall.SIGTERM)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
 	go func() {
-		sig := <-sigc
-		p.Signal(sig)
+		// SIGHUP reloads the config file in the child, other signals stop it.
+		for sig := range sigc {
+			p.Signal(sig)
+		}
 	}()
 
 	st, err := p.Wait()
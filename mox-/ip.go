@@ -1,7 +1,10 @@
 package mox
 
 import (
+	"errors"
+	"fmt"
 	"net"
+	"syscall"
 )
 
 // Network returns tcp4 or tcp6, depending on the ip.
@@ -19,3 +22,95 @@ func Network(ip string) string {
 	}
 	return "tcp6"
 }
+
+// SpecialPurposeNets are the IP ranges that are not globally reachable or have a
+// special purpose, based on the IANA IPv4 and IPv6 special-purpose address
+// registries: e.g. loopback, private, link-local, shared address space (CGNAT),
+// benchmarking, documentation, multicast, and IPv4/IPv6 translation and tunneling
+// ranges that embed IPv4 addresses. Connections made on behalf of users, e.g.
+// fetching remote content or delivering push notifications, must not go to these
+// ranges.
+var SpecialPurposeNets = mustParseNets(
+	// IPv4.
+	"0.0.0.0/8",       // "This network".
+	"10.0.0.0/8",      // Private.
+	"100.64.0.0/10",   // Shared address space, CGNAT.
+	"127.0.0.0/8",     // Loopback.
+	"169.254.0.0/16",  // Link-local, includes cloud metadata services.
+	"172.16.0.0/12",   // Private.
+	"192.0.0.0/24",    // IETF protocol assignments.
+	"192.0.2.0/24",    // Documentation.
+	"192.31.196.0/24", // AS112.
+	"192.52.193.0/24", // AMT.
+	"192.88.99.0/24",  // Deprecated 6to4 relay anycast.
+	"192.168.0.0/16",  // Private.
+	"192.175.48.0/24", // AS112 direct delegation.
+	"198.18.0.0/15",   // Benchmarking.
+	"198.51.100.0/24", // Documentation.
+	"203.0.113.0/24",  // Documentation.
+	"224.0.0.0/4",     // Multicast.
+	"240.0.0.0/4",     // Reserved, includes limited broadcast.
+
+	// IPv6. IPv4-mapped addresses are checked against the IPv4 ranges.
+	"::/96",          // Unspecified, loopback and deprecated IPv4-compatible.
+	"64:ff9b::/96",   // IPv4/IPv6 translation, NAT64.
+	"64:ff9b:1::/48", // Local-use IPv4/IPv6 translation.
+	"100::/64",       // Discard-only.
+	"2001::/23",      // IETF protocol assignments, includes Teredo.
+	"2001:db8::/32",  // Documentation.
+	"2002::/16",      // 6to4.
+	"3fff::/20",      // Documentation.
+	"5f00::/16",      // Segment routing SIDs.
+	"fc00::/7",       // Unique local.
+	"fe80::/10",      // Link-local.
+	"fec0::/10",      // Deprecated site-local.
+	"ff00::/8",       // Multicast.
+)
+
+func mustParseNets(l ...string) []*net.IPNet {
+	var r []*net.IPNet
+	for _, s := range l {
+		_, ipnet, err := net.ParseCIDR(s)
+		if err != nil {
+			panic(fmt.Sprintf("parsing ip network %q: %v", s, err))
+		}
+		r = append(r, ipnet)
+	}
+	return r
+}
+
+// IsSpecialPurposeIP returns whether ip is in one of SpecialPurposeNets. IPv4-mapped
+// IPv6 addresses are checked as IPv4 address. Invalid IPs are also considered
+// special-purpose.
+func IsSpecialPurposeIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	} else if len(ip) != net.IPv6len {
+		return true
+	}
+	for _, n := range SpecialPurposeNets {
+		if len(n.IP) == len(ip) && n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrSpecialPurposeIP is returned by PublicDialControl for connections to
+// special-purpose IPs.
+var ErrSpecialPurposeIP = errors.New("connection to special-purpose ip address not allowed")
+
+// PublicDialControl can be used as Control function for a net.Dialer. It
+// refuses connections to special-purpose IPs, see SpecialPurposeNets. Because the
+// check is done on the resolved address, right before connecting, DNS names
+// resolving to internal IPs are refused as well.
+func PublicDialControl(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if IsSpecialPurposeIP(net.ParseIP(host)) {
+		return fmt.Errorf("%w: %s", ErrSpecialPurposeIP, host)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package mox
+
+import (
+	"net"
+	"testing"
+)
+
+func TestIsSpecialPurposeIP(t *testing.T) {
+	test := func(s string, exp bool) {
+		t.Helper()
+		if got := IsSpecialPurposeIP(net.ParseIP(s)); got != exp {
+			t.Fatalf("IsSpecialPurposeIP(%q) = %v, expected %v", s, got, exp)
+		}
+	}
+
+	test("", true)
+	test("127.0.0.1", true)
+	test("10.1.2.3", true)
+	test("100.64.0.1", true)
+	test("169.254.169.254", true)
+	test("192.0.0.8", true)
+	test("198.18.0.1", true)
+	test("255.255.255.255", true)
+	test("::1", true)
+	test("::", true)
+	test("::ffff:127.0.0.1", true)
+	test("::ffff:100.64.0.1", true)
+	test("64:ff9b::a00:1", true)
+	test("fd00::1", true)
+	test("fe80::1", true)
+	test("2001:db8::1", true)
+
+	test("1.1.1.1", false)
+	test("::ffff:1.1.1.1", false)
+	test("2a01:4f8::1", false)
+	test("2600::1", false)
+}
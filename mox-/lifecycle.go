@@ -7,8 +7,10 @@ import (
 	"os"
 	"runtime"
 	"runtime/debug"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -22,6 +24,31 @@ import (
 var passedListeners = map[string]*os.File{} // Listen address to file descriptor.
 var passedFiles = map[string][]*os.File{}   // Path to file descriptors.
 
+// Listen addresses for which a listener was created, for health checks.
+var bound = struct {
+	sync.Mutex
+	addrs map[string]bool
+}{addrs: map[string]bool{}}
+
+// Started is set after all listeners have been created and are serving, at the
+// end of startup.
+var Started atomic.Bool
+
+// ListenersPending returns the listen addresses passed by the privileged parent
+// process for which no listener has been created yet.
+func ListenersPending() []string {
+	bound.Lock()
+	defer bound.Unlock()
+	var l []string
+	for addr := range passedListeners {
+		if !bound.addrs[addr] {
+			l = append(l, addr)
+		}
+	}
+	sort.Strings(l)
+	return l
+}
+
 // RestorePassedFiles reads addresses from $MOX_SOCKETS and paths from $MOX_FILES
 // and prepares an os.File for each file descriptor, which are used by later calls
 // of Listen or opening files.
@@ -76,8 +103,19 @@ var FilesImmediate bool
 
 // Listen returns a newly created network listener when starting as root, and
 // otherwise (not root) returns a network listener from a file descriptor that was
-// passed by the parent root process.
+// passed by the parent root process. Connections from networks banned through
+// AutoBan are closed by the listener.
 func Listen(network, addr string) (net.Listener, error) {
+	ln, err := ListenNoAutoBan(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return autoBanListener{ln}, nil
+}
+
+// ListenNoAutoBan is like Listen, but the listener does not close connections
+// from banned networks, e.g. for SMTP for incoming delivery.
+func ListenNoAutoBan(network, addr string) (net.Listener, error) {
 	if os.Getuid() != 0 && !FilesImmediate {
 		f, ok := passedListeners[addr]
 		if !ok {
@@ -87,6 +125,9 @@ func Listen(network, addr string) (net.Listener, error) {
 		if err != nil {
 			return nil, fmt.Errorf("making network listener from file descriptor for address %s: %v", addr, err)
 		}
+		bound.Lock()
+		bound.addrs[addr] = true
+		bound.Unlock()
 		return ln, nil
 	}
 
@@ -111,9 +152,57 @@ func Listen(network, addr string) (net.Listener, error) {
 		}
 		passedListeners[addr] = f
 	}
+	bound.Lock()
+	bound.addrs[addr] = true
+	bound.Unlock()
 	return ln, err
 }
 
+// ListenPacket returns a packet connection, e.g. UDP for QUIC, like Listen returns
+// a listener. Packet connections are stored in the passed listeners with the
+// address prefixed with "udp:".
+func ListenPacket(network, addr string) (net.PacketConn, error) {
+	key := "udp:" + addr
+	if os.Getuid() != 0 && !FilesImmediate {
+		f, ok := passedListeners[key]
+		if !ok {
+			return nil, fmt.Errorf("no file descriptor for packet listener %s", addr)
+		}
+		conn, err := net.FilePacketConn(f)
+		if err != nil {
+			return nil, fmt.Errorf("making packet connection from file descriptor for address %s: %v", addr, err)
+		}
+		bound.Lock()
+		bound.addrs[key] = true
+		bound.Unlock()
+		return autoBanPacketConn{conn}, nil
+	}
+
+	if _, ok := passedListeners[key]; ok {
+		return nil, fmt.Errorf("duplicate packet listener: %s", addr)
+	}
+
+	conn, err := net.ListenPacket(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if !FilesImmediate {
+		udpconn, ok := conn.(*net.UDPConn)
+		if !ok {
+			return nil, fmt.Errorf("packet connection not udp, but %T, for network %s, address %s", conn, network, addr)
+		}
+		f, err := udpconn.File()
+		if err != nil {
+			return nil, fmt.Errorf("dup packet connection: %v", err)
+		}
+		passedListeners[key] = f
+	}
+	bound.Lock()
+	bound.addrs[key] = true
+	bound.Unlock()
+	return autoBanPacketConn{conn}, nil
+}
+
 // Open a privileged file, such as a TLS private key. When running as root
 // (during startup), the file is opened and the file descriptor is stored.
 // These file descriptors are passed to the unprivileged process. When in the
@@ -171,6 +260,17 @@ var Connections = &connections{
 	active: map[connKind]int64{},
 }
 
+var metricConnections = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "mox_connections_total",
+		Help: "Incoming connections, per protocol/listener.",
+	},
+	[]string{
+		"protocol", // smtp, imap
+		"listener",
+	},
+)
+
 type connKind struct {
 	protocol string
 	listener string
@@ -199,6 +299,7 @@ func (c *connections) Register(nc net.Conn, protocol, listener string) {
 	}
 
 	ck := connKind{protocol, listener}
+	metricConnections.WithLabelValues(protocol, listener).Inc()
 
 	c.activeMutex.Lock()
 	c.active[ck]++
@@ -276,3 +377,37 @@ func (c *connections) Done() chan struct{} {
 	c.dones = append(c.dones, done)
 	return done
 }
+
+// ConnectionCount is the number of open connections for a protocol and listener.
+type ConnectionCount struct {
+	Protocol string   // E.g. smtp, imap, smtpclient.
+	Listener string   // Name of listener, or "queue" for outgoing connections.
+	Count    int64    // Open connections.
+	Remotes  []string // Remote addresses of the open connections.
+}
+
+// Counts returns the number of open connections per protocol and listener,
+// including those without open connections that had connections before.
+func (c *connections) Counts() []ConnectionCount {
+	c.Lock()
+	defer c.Unlock()
+	c.activeMutex.Lock()
+	defer c.activeMutex.Unlock()
+
+	remotes := map[connKind][]string{}
+	for nc, ck := range c.conns {
+		remotes[ck] = append(remotes[ck], nc.RemoteAddr().String())
+	}
+	var l []ConnectionCount
+	for ck, n := range c.active {
+		sort.Strings(remotes[ck])
+		l = append(l, ConnectionCount{ck.protocol, ck.listener, n, remotes[ck]})
+	}
+	sort.Slice(l, func(i, j int) bool {
+		if l[i].Protocol != l[j].Protocol {
+			return l[i].Protocol < l[j].Protocol
+		}
+		return l[i].Listener < l[j].Listener
+	})
+	return l
+}
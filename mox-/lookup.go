@@ -2,6 +2,8 @@ package mox
 
 import (
 	"errors"
+	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/mjl-/mox/config"
@@ -14,6 +16,23 @@ var (
 	ErrAddressNotFound = errors.New("address not found")
 )
 
+// RoleLocalparts are the localparts of role addresses that exist for each
+// configured domain, see RFC 2142. Messages to role addresses without an explicit
+// destination or catchall address are delivered to the postmaster account and
+// mailbox.
+var RoleLocalparts = []smtp.Localpart{"postmaster", "abuse", "security"}
+
+// IsRoleLocalpart returns whether localpart is one of RoleLocalparts, compared
+// case-insensitively.
+func IsRoleLocalpart(localpart smtp.Localpart) bool {
+	for _, lp := range RoleLocalparts {
+		if strings.EqualFold(string(localpart), string(lp)) {
+			return true
+		}
+	}
+	return false
+}
+
 // FindAccount looks up the account for localpart and domain.
 //
 // Can return ErrDomainNotFound and ErrAddressNotFound.
@@ -69,6 +88,8 @@ func LookupAddress(localpart smtp.Localpart, domain dns.Domain, allowPostmaster,
 		if accAddr, alias, ok = Conf.AccountDestination("@" + domain.Name()); !ok || alias != nil {
 			if localpart == "postmaster" && allowPostmaster {
 				return Conf.Static.Postmaster.Account, nil, "postmaster", config.Destination{Mailbox: Conf.Static.Postmaster.Mailbox}, nil
+			} else if IsRoleLocalpart(localpart) && allowPostmaster {
+				return Conf.Static.Postmaster.Account, nil, canonical, config.Destination{Mailbox: Conf.Static.Postmaster.Mailbox}, nil
 			}
 			return "", nil, "", config.Destination{}, ErrAddressNotFound
 		}
@@ -109,3 +130,48 @@ func AllowMsgFrom(accountName string, msgFrom smtp.Address) bool {
 	}
 	return accName == accountName
 }
+
+// LookupSpamtrap returns whether localpart and domain are a configured spamtrap
+// address, and the canonical address if so.
+func LookupSpamtrap(localpart smtp.Localpart, domain dns.Domain) (string, bool) {
+	d, ok := Conf.Domain(domain)
+	if !ok || len(d.SpamtrapLocalparts) == 0 {
+		return "", false
+	}
+	localpart = CanonicalLocalpart(localpart, d)
+	if _, ok := d.SpamtrapLocalparts[localpart]; !ok {
+		return "", false
+	}
+	return smtp.NewAddress(localpart, domain).Pack(true), true
+}
+
+// RewriteAddress applies the first matching address rewrite rule for sender or
+// recipient addresses to addr. The rules of the domain of addr and the global
+// rules are evaluated by descending priority. If no rule matches, addr is
+// returned with rewritten false. Rewritten sender addresses must be in a
+// configured domain.
+func RewriteAddress(addr smtp.Address, sender bool) (raddr smtp.Address, rewritten bool, rerr error) {
+	domainRules, globalRules := Conf.AddressRewrites(addr.Domain)
+	rules := append(append([]config.AddressRewrite{}, domainRules...), globalRules...)
+	sort.SliceStable(rules, func(i, j int) bool {
+		return rules[i].Priority > rules[j].Priority
+	})
+
+	s := addr.String()
+	for _, r := range rules {
+		if sender && !r.Sender || !sender && !r.Recipient || r.Address == nil || !r.Address.MatchString(s) {
+			continue
+		}
+		ns := r.Address.ReplaceAllString(s, r.Replace)
+		naddr, err := smtp.ParseAddress(ns)
+		if err != nil {
+			return addr, false, fmt.Errorf("rewriting address %s with rule for %q results in invalid address %q: %v", s, r.AddressRegexp, ns, err)
+		}
+		if _, ok := Conf.Domain(naddr.Domain); sender && !ok {
+			// We need the domain config for DKIM signing.
+			return addr, false, fmt.Errorf("rewritten sender address %s is not in a configured domain", naddr)
+		}
+		return naddr, naddr != addr, nil
+	}
+	return addr, false, nil
+}
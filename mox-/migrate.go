@@ -0,0 +1,290 @@
+package mox
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/moxio"
+	"github.com/mjl-/mox/smtp"
+)
+
+// ConfigDynamicVersion is the current schema version of domains.conf. When the
+// config is changed in a way that older files no longer parse or would lose
+// settings, e.g. by renaming or restructuring fields, the version is incremented
+// and a migration is added to dynamicMigrations.
+const ConfigDynamicVersion = 1
+
+// dynamicMigrations upgrade the config file text from the previous version to
+// Version. Migrations work on lines, not on parsed config, because older files may
+// not parse with the current config types. Each returns descriptions of its
+// changes.
+var dynamicMigrations = []struct {
+	Version int
+	Migrate func(root *confNode) ([]string, error)
+}{
+	{1, migrateDestinationLocalparts},
+}
+
+// confNode is a line in an sconf file, with its indented child lines.
+type confNode struct {
+	pre      []string // Comment or empty lines before this line, as is.
+	depth    int      // Number of tabs for indent.
+	key      string   // Key, "-" for list elements. Empty for other lines, e.g. includes.
+	value    string   // Value after "key: " or "- ".
+	line     string   // Line without indent, for lines without key.
+	children []*confNode
+}
+
+// child returns the first child node with key.
+func (n *confNode) child(key string) *confNode {
+	for _, c := range n.children {
+		if c.key == key {
+			return c
+		}
+	}
+	return nil
+}
+
+// parseConfNodes parses the lines of an sconf file into a tree, returning the
+// root node with the top-level lines as children, and trailing comment or empty
+// lines.
+func parseConfNodes(buf []byte) (*confNode, []string, error) {
+	root := &confNode{depth: -1}
+	stack := []*confNode{root}
+	var pre []string
+	lines := strings.Split(string(buf), "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	for i, line := range lines {
+		line = strings.TrimSuffix(line, "\r")
+		t := strings.TrimLeft(line, "\t")
+		if strings.TrimSpace(t) == "" || strings.HasPrefix(t, "#") {
+			pre = append(pre, line)
+			continue
+		}
+		depth := len(line) - len(t)
+		// Values of maps can be indented by more than one tab.
+		for stack[len(stack)-1].depth >= depth {
+			stack = stack[:len(stack)-1]
+		}
+		if depth > 0 && len(stack) == 1 {
+			return nil, nil, fmt.Errorf("line %d: unexpected indent", i+1)
+		}
+		n := &confNode{pre: pre, depth: depth}
+		pre = nil
+		if t == "-" || strings.HasPrefix(t, "- ") {
+			n.key = "-"
+			n.value = strings.TrimPrefix(strings.TrimPrefix(t, "-"), " ")
+		} else if k, v, ok := strings.Cut(t, ":"); ok && !strings.HasPrefix(t, "!") {
+			n.key = k
+			n.value = strings.TrimPrefix(v, " ")
+		} else {
+			n.line = t
+		}
+		parent := stack[len(stack)-1]
+		parent.children = append(parent.children, n)
+		stack = append(stack, n)
+	}
+	return root, pre, nil
+}
+
+// writeConfNodes writes the lines of the children of n.
+func writeConfNodes(b *strings.Builder, n *confNode) {
+	for _, c := range n.children {
+		for _, s := range c.pre {
+			b.WriteString(s + "\n")
+		}
+		b.WriteString(strings.Repeat("\t", c.depth))
+		switch {
+		case c.key == "":
+			b.WriteString(c.line)
+		case c.value == "" && c.key == "-":
+			b.WriteString("-")
+		case c.value == "":
+			b.WriteString(c.key + ":")
+		case c.key == "-":
+			b.WriteString("- " + c.value)
+		default:
+			b.WriteString(c.key + ": " + c.value)
+		}
+		b.WriteString("\n")
+		writeConfNodes(b, c)
+	}
+}
+
+// migrateDynamic migrates the text of a domains.conf file to the current
+// version, returning the new text and descriptions of the changes. If the file
+// is already at the current version, buf is returned without changes.
+func migrateDynamic(buf []byte) ([]byte, []string, error) {
+	root, post, err := parseConfNodes(buf)
+	if err != nil {
+		return nil, nil, err
+	}
+	var version int
+	vn := root.child("Version")
+	if vn != nil {
+		version, err = strconv.Atoi(vn.value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("parsing version: %v", err)
+		}
+	}
+	if version > ConfigDynamicVersion {
+		return nil, nil, fmt.Errorf("config has version %d, this mox only supports up to version %d, upgrade mox", version, ConfigDynamicVersion)
+	} else if version == ConfigDynamicVersion {
+		return buf, nil, nil
+	}
+
+	var changes []string
+	for _, m := range dynamicMigrations {
+		if m.Version <= version {
+			continue
+		}
+		l, err := m.Migrate(root)
+		if err != nil {
+			return nil, nil, fmt.Errorf("migrating to version %d: %v", m.Version, err)
+		}
+		changes = append(changes, l...)
+	}
+	if vn == nil {
+		vn = &confNode{key: "Version"}
+		root.children = append(root.children, vn)
+	}
+	vn.value = fmt.Sprintf("%d", ConfigDynamicVersion)
+	changes = append(changes, fmt.Sprintf("version set to %d", ConfigDynamicVersion))
+
+	var b strings.Builder
+	writeConfNodes(&b, root)
+	for _, s := range post {
+		b.WriteString(s + "\n")
+	}
+	return []byte(b.String()), changes, nil
+}
+
+// migrateDestinationLocalparts replaces account destinations that are only a
+// localpart with a full email address, with the default domain of the account.
+func migrateDestinationLocalparts(root *confNode) ([]string, error) {
+	accounts := root.child("Accounts")
+	if accounts == nil {
+		return nil, nil
+	}
+	var changes []string
+	for _, acc := range accounts.children {
+		dn := acc.child("Domain")
+		dests := acc.child("Destinations")
+		if dn == nil || dests == nil {
+			continue
+		}
+		d, err := dns.ParseDomain(dn.value)
+		if err != nil {
+			// Reported when the config is validated.
+			continue
+		}
+		for _, dest := range dests.children {
+			if dest.key == "" || strings.HasPrefix(dest.key, "@") {
+				continue
+			}
+			lp, err := smtp.ParseLocalpart(dest.key)
+			if err != nil {
+				continue
+			}
+			addr := smtp.NewAddress(lp, d).Pack(true)
+			if dests.child(addr) != nil {
+				return nil, fmt.Errorf("account %q has destination %q for localpart and for full address %q", acc.key, dest.key, addr)
+			}
+			changes = append(changes, fmt.Sprintf("account %s: destination %s replaced with %s", acc.key, dest.key, addr))
+			dest.key = addr
+		}
+	}
+	return changes, nil
+}
+
+// ConfigMigration is the result of migrating domains.conf to the current
+// version.
+type ConfigMigration struct {
+	Diff    string   // Changed lines, see StaticReload.Diff. Empty for files with includes, environment variables or file references.
+	Changes []string // Descriptions of changes, empty if the file is at the current version.
+	Written bool     // Whether the file was updated.
+}
+
+// ConfigMigrate migrates domains.conf to the current version. Older files are
+// already migrated in memory when parsed, so they keep working. Writing the
+// migrated file makes the changes permanent and visible. Files with
+// includes, environment variables or file references are not written, because
+// included files would not be migrated, and values would be replaced; an error is
+// returned for such files that need changes, after which the file should be
+// updated manually.
+func ConfigMigrate(log mlog.Log, dryRun bool) (ConfigMigration, error) {
+	Conf.dynamicMutex.Lock()
+	defer Conf.dynamicMutex.Unlock()
+
+	buf, err := os.ReadFile(ConfigDynamicPath)
+	if err != nil {
+		return ConfigMigration{}, fmt.Errorf("reading domains.conf: %v", err)
+	}
+	processed, err := configFileProcessed(ConfigDynamicPath)
+	if err != nil {
+		return ConfigMigration{}, fmt.Errorf("reading domains.conf: %v", err)
+	}
+	if processed {
+		cf, err := readConfigFile(ConfigDynamicPath)
+		if err != nil {
+			return ConfigMigration{}, fmt.Errorf("%w: %v", ErrConfig, err)
+		}
+		_, changes, err := migrateDynamic(cf.buf)
+		if err != nil {
+			return ConfigMigration{}, fmt.Errorf("%w: %v", ErrConfig, err)
+		}
+		m := ConfigMigration{Changes: changes}
+		if len(changes) > 0 && !dryRun {
+			return m, fmt.Errorf("%w: domains.conf has includes, environment variables or file references, make the changes manually: %s", ErrConfig, strings.Join(changes, "; "))
+		}
+		return m, nil
+	}
+
+	nbuf, changes, err := migrateDynamic(buf)
+	if err != nil {
+		return ConfigMigration{}, fmt.Errorf("%w: %v", ErrConfig, err)
+	}
+	m := ConfigMigration{Diff: lineDiff(string(buf), string(nbuf)), Changes: changes}
+	if len(changes) == 0 || dryRun {
+		return m, nil
+	}
+
+	// Written in place like writeDynamic, keeping ownership and permissions.
+	f, err := os.OpenFile(ConfigDynamicPath, os.O_WRONLY, 0660)
+	if err != nil {
+		return m, err
+	}
+	defer func() {
+		if f != nil {
+			err := f.Close()
+			log.Check(err, "closing file after error")
+		}
+	}()
+	if _, err := f.Write(nbuf); err != nil {
+		return m, fmt.Errorf("write domains.conf: %v", err)
+	}
+	if err := f.Truncate(int64(len(nbuf))); err != nil {
+		return m, fmt.Errorf("truncate domains.conf after write: %v", err)
+	}
+	if err := f.Sync(); err != nil {
+		return m, fmt.Errorf("sync domains.conf after write: %v", err)
+	}
+	if err := moxio.SyncDir(log, filepath.Dir(ConfigDynamicPath)); err != nil {
+		return m, fmt.Errorf("sync dir of domains.conf after write: %v", err)
+	}
+	err = f.Close()
+	f = nil
+	if err != nil {
+		return m, fmt.Errorf("close written domains.conf: %v", err)
+	}
+	m.Written = true
+	// The changed mtime causes the file to be reloaded, which is harmless.
+	return m, nil
+}
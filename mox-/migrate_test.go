@@ -0,0 +1,104 @@
+package mox
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMigrateDynamic(t *testing.T) {
+	const old = `# Comment.
+Domains:
+	mox.example: nil
+Accounts:
+	mjl:
+		Domain: mox.example
+		Destinations:
+			# Localpart only.
+			mjl: nil
+			@mox.example: nil
+			other@mox.example:
+				Rulesets:
+					-
+						VerifiedDomain: mox.example
+						Mailbox: Lists
+					-
+						HeadersRegexp:
+								subject: test
+						Mailbox: Test
+!include more.conf
+# Trailing comment.
+`
+	buf, changes, err := migrateDynamic([]byte(old))
+	if err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	exp := strings.Replace(old, "mjl: nil", "mjl@mox.example: nil", 1)
+	exp = strings.Replace(exp, "!include more.conf\n", "!include more.conf\nVersion: 1\n", 1)
+	if string(buf) != exp {
+		t.Fatalf("got:\n%s\nexpected:\n%s", buf, exp)
+	}
+	expChanges := []string{"account mjl: destination mjl replaced with mjl@mox.example", "version set to 1"}
+	if !reflect.DeepEqual(changes, expChanges) {
+		t.Fatalf("got changes %v, expected %v", changes, expChanges)
+	}
+
+	// Current version is not changed.
+	nbuf, changes, err := migrateDynamic(buf)
+	if err != nil || string(nbuf) != string(buf) || len(changes) != 0 {
+		t.Fatalf("migrate current version: got %q, %v, %v", nbuf, changes, err)
+	}
+
+	if _, _, err := migrateDynamic([]byte("Version: 1000\n")); err == nil {
+		t.Fatalf("migrate newer version: expected error")
+	}
+	if _, _, err := migrateDynamic([]byte("Accounts:\n\tmjl:\n\t\tDomain: mox.example\n\t\tDestinations:\n\t\t\tmjl: nil\n\t\t\tmjl@mox.example: nil\n")); err == nil {
+		t.Fatalf("migrate duplicate destination: expected error")
+	}
+}
+
+func TestConfigMigrate(t *testing.T) {
+	dir := t.TempDir()
+	ConfigDynamicPath = filepath.Join(dir, "domains.conf")
+	defer func() {
+		ConfigDynamicPath = ""
+	}()
+
+	const old = "Domains:\n\tmox.example: nil\nAccounts:\n\tmjl:\n\t\tDomain: mox.example\n\t\tDestinations:\n\t\t\tmjl: nil\n"
+	err := os.WriteFile(ConfigDynamicPath, []byte(old), 0660)
+	if err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+
+	m, err := ConfigMigrate(pkglog, true)
+	if err != nil || m.Written || len(m.Changes) != 2 || !strings.Contains(m.Diff, "+\t\t\tmjl@mox.example: nil\n") {
+		t.Fatalf("dry run: got %#v, %v", m, err)
+	}
+	if buf, _ := os.ReadFile(ConfigDynamicPath); string(buf) != old {
+		t.Fatalf("dry run changed file")
+	}
+
+	m, err = ConfigMigrate(pkglog, false)
+	if err != nil || !m.Written {
+		t.Fatalf("migrate: got %#v, %v", m, err)
+	}
+	m, err = ConfigMigrate(pkglog, false)
+	if err != nil || m.Written || len(m.Changes) != 0 {
+		t.Fatalf("migrate again: got %#v, %v", m, err)
+	}
+
+	// Files with includes are not written.
+	err = os.WriteFile(filepath.Join(dir, "accounts.conf"), []byte("mjl:\n\tDomain: mox.example\n"), 0660)
+	if err == nil {
+		err = os.WriteFile(ConfigDynamicPath, []byte("Domains:\n\tmox.example: nil\nAccounts:\n\t!include accounts.conf\n"), 0660)
+	}
+	if err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	m, err = ConfigMigrate(pkglog, false)
+	if err == nil || m.Written || m.Diff != "" || len(m.Changes) != 1 {
+		t.Fatalf("migrate with include: got %#v, %v, expected error", m, err)
+	}
+}
@@ -0,0 +1,221 @@
+package mox
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/moxvar"
+)
+
+var metricPasswordPolicyRefused = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "mox_password_policy_refused_total",
+		Help: "New passwords refused by the password policy.",
+	},
+	[]string{
+		"reason", // length, entropy, denylist, breached
+	},
+)
+
+// ErrPasswordPolicy is wrapped by errors for passwords that don't meet the
+// password policy.
+var ErrPasswordPolicy = errors.New("password does not meet policy")
+
+// Default base URL for the range API of Have I Been Pwned.
+const breachCheckURL = "https://api.pwnedpasswords.com/range/"
+
+// checkPasswordPolicy checks the configuration of a password policy.
+func checkPasswordPolicy(p config.PasswordPolicy) error {
+	if p.MinLength != 0 && p.MinLength < 8 {
+		return fmt.Errorf("minimum length must be at least 8, or 0 for the default")
+	}
+	if p.MinEntropy < 0 {
+		return fmt.Errorf("minimum entropy must not be negative")
+	}
+	if p.BreachCheckURL != "" {
+		u, err := url.Parse(p.BreachCheckURL)
+		if err != nil {
+			return fmt.Errorf("parsing breach check url: %v", err)
+		} else if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("breach check url must be http or https")
+		}
+	}
+	return nil
+}
+
+// AccountPasswordPolicy returns the password policy for new passwords of the
+// account: its own, or the global policy. Nil if there is no policy.
+func AccountPasswordPolicy(accountName string) *config.PasswordPolicy {
+	if acc, ok := Conf.Account(accountName); ok && acc.PasswordPolicy != nil {
+		return acc.PasswordPolicy
+	}
+	return Conf.Static.PasswordPolicy
+}
+
+// PasswordPolicyCheck checks password as new password for the account, against
+// the password policy of the account, or the global password policy. Errors for
+// passwords that don't meet the policy wrap ErrPasswordPolicy and are meant for
+// users. Other errors, e.g. about reading the deny list file, are not.
+func PasswordPolicyCheck(ctx context.Context, log mlog.Log, accountName, password string) error {
+	policy := AccountPasswordPolicy(accountName)
+
+	refuse := func(reason, format string, args ...any) error {
+		metricPasswordPolicyRefused.WithLabelValues(reason).Inc()
+		log.Info("password refused by policy", slog.String("account", accountName), slog.String("reason", reason))
+		return fmt.Errorf("%w: %s", ErrPasswordPolicy, fmt.Sprintf(format, args...))
+	}
+
+	minLength := 8
+	if policy != nil && policy.MinLength > minLength {
+		minLength = policy.MinLength
+	}
+	if utf8.RuneCountInString(password) < minLength {
+		return refuse("length", "must be at least %d characters", minLength)
+	}
+	if policy == nil {
+		return nil
+	}
+
+	if policy.MinEntropy > 0 {
+		if bits := passwordEntropy(password); bits < float64(policy.MinEntropy) {
+			return refuse("entropy", "too easy to guess, estimated strength is %d bits, at least %d required, use a longer password with more kinds of characters", int(bits), policy.MinEntropy)
+		}
+	}
+
+	equal := func(s string) bool {
+		return strings.EqualFold(strings.TrimSpace(s), password)
+	}
+	if equal(accountName) || slices.ContainsFunc(policy.DenyList, equal) {
+		return refuse("denylist", "too common, choose another password")
+	}
+	if policy.DenyListFile != "" {
+		f, err := os.Open(ConfigDirPath(policy.DenyListFile))
+		if err != nil {
+			return fmt.Errorf("open password deny list: %v", err)
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if equal(scanner.Text()) {
+				return refuse("denylist", "too common, choose another password")
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("reading password deny list: %v", err)
+		}
+	}
+
+	if policy.BreachCheck {
+		baseURL := policy.BreachCheckURL
+		if baseURL == "" {
+			baseURL = breachCheckURL
+		}
+		breached, err := passwordBreached(ctx, baseURL, password)
+		if err != nil {
+			log.Errorx("checking password against breached passwords, accepting password", err, slog.String("account", accountName))
+		} else if breached {
+			return refuse("breached", "found in data breaches, choose another password")
+		}
+	}
+	return nil
+}
+
+// passwordEntropy returns a rough estimate of the strength of a password in bits:
+// the number of characters times the bits for the classes of characters used.
+// Characters that repeat the previous character, or are the next or previous
+// character in a sequence, don't count.
+func passwordEntropy(password string) float64 {
+	var lower, upper, digit, other, nonASCII bool
+	var n int
+	prev := rune(-2)
+	for _, c := range password {
+		switch {
+		case c >= 'a' && c <= 'z':
+			lower = true
+		case c >= 'A' && c <= 'Z':
+			upper = true
+		case c >= '0' && c <= '9':
+			digit = true
+		case c < utf8.RuneSelf:
+			other = true
+		default:
+			nonASCII = true
+		}
+		if c < prev-1 || c > prev+1 {
+			n++
+		}
+		prev = c
+	}
+	var pool int
+	for _, t := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {other, 33}, {nonASCII, 100}} {
+		if t.used {
+			pool += t.size
+		}
+	}
+	if pool == 0 {
+		return 0
+	}
+	return float64(n) * math.Log2(float64(pool))
+}
+
+// passwordBreached looks up password with the range API at baseURL, with the
+// k-anonymity model of Have I Been Pwned: only the first 5 hexadecimal characters
+// of the SHA-1 hash are sent, and the response lists the remaining characters of
+// all hashes with that prefix.
+func passwordBreached(ctx context.Context, baseURL, password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", baseURL+hash[:5], nil)
+	if err != nil {
+		return false, fmt.Errorf("new request: %v", err)
+	}
+	req.Header.Set("User-Agent", fmt.Sprintf("mox/%s (password breach check)", moxvar.Version))
+	// Padding adds entries with count 0, so the response size does not reveal the
+	// number of matching hashes.
+	req.Header.Set("Add-Padding", "true")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("http transaction: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("http response status %s", resp.Status)
+	}
+
+	scanner := bufio.NewScanner(io.LimitReader(resp.Body, 4*1024*1024))
+	for scanner.Scan() {
+		suffix, count, ok := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if ok && strings.EqualFold(suffix, hash[5:]) && count != "0" {
+			return true, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, fmt.Errorf("reading response: %v", err)
+	}
+	return false, nil
+}
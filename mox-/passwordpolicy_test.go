@@ -0,0 +1,97 @@
+package mox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mjl-/mox/config"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	ctx := context.Background()
+	log := pkglog
+
+	// SHA-1 of "password1" is E38AD214943DAAD1D64C102FAEC29DE4AFE9DA3D, of "correct
+	// horse battery staple" ABF7AAD6438836DBE526AA231ABDE2D0EEF74D42.
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/range/E38AD" && r.URL.Path != "/range/ABF7A" {
+			http.Error(w, "unexpected prefix", http.StatusBadRequest)
+			return
+		}
+		if r.Header.Get("Add-Padding") != "true" {
+			http.Error(w, "missing padding header", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, "0018A45C4D1DEF81644B54AB7F969B88D65:1\r\n214943DAAD1D64C102FAEC29DE4AFE9DA3D:2413945\r\n")
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	ConfigStaticPath = filepath.Join(dir, "mox.conf")
+	defer func() {
+		ConfigStaticPath = ""
+	}()
+	err := os.WriteFile(filepath.Join(dir, "denylist.txt"), []byte("letmeinplease\n Sunshine123 \n"), 0660)
+	if err != nil {
+		t.Fatalf("write deny list: %v", err)
+	}
+
+	check := func(password string, expErr bool) {
+		t.Helper()
+		err := PasswordPolicyCheck(ctx, log, "mjl", password)
+		if expErr && !errors.Is(err, ErrPasswordPolicy) || !expErr && err != nil {
+			t.Fatalf("password %q: got err %v, expected policy error %v", password, err, expErr)
+		}
+	}
+
+	// Without policy, only the minimum length applies.
+	check("short", true)
+	check("password1", false)
+
+	Conf.Static.PasswordPolicy = &config.PasswordPolicy{
+		MinLength:      9,
+		MinEntropy:     40,
+		DenyList:       []string{"Tr0ub4dor&3x"},
+		DenyListFile:   "denylist.txt",
+		BreachCheck:    true,
+		BreachCheckURL: srv.URL + "/range/",
+	}
+	defer func() {
+		Conf.Static.PasswordPolicy = nil
+	}()
+	check("Short1!x", true)       // Too short.
+	check("aaaaaaaaaaaa", true)   // Too little entropy.
+	check("abcdefghijklmn", true) // Sequence.
+	check("tr0ub4dor&3X", true)   // Deny list.
+	check("sunshine123", true)    // Deny list file.
+	check("password1", true)      // Breached.
+	check("correct horse battery staple", false)
+	if requests != 2 {
+		t.Fatalf("got %d breach check requests, expected 2", requests)
+	}
+
+	// Failing breach check accepts password.
+	Conf.Static.PasswordPolicy.BreachCheckURL = srv.URL + "/other/"
+	check("correct horse battery staple", false)
+
+	// Missing deny list file is not a policy error.
+	Conf.Static.PasswordPolicy.DenyListFile = "missing.txt"
+	err = PasswordPolicyCheck(ctx, log, "mjl", "correct horse battery staple")
+	if err == nil || errors.Is(err, ErrPasswordPolicy) {
+		t.Fatalf("got err %v, expected non-policy error for missing deny list file", err)
+	}
+
+	// Repeats and sequences count as one character.
+	if bits, exp := passwordEntropy("aaa123"), 2*math.Log2(36); math.Abs(bits-exp) > 0.001 {
+		t.Fatalf("got entropy %v for aaa123, expected %v", bits, exp)
+	}
+}
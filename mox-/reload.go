@@ -0,0 +1,234 @@
+package mox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/mjl-/sconf"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/mlog"
+)
+
+// Fields of the static config that are read when used, and can be changed while
+// running. Other fields, such as Listeners, DataDir and ACME, are only used
+// during startup and require a restart.
+var staticReloadable = map[string]bool{
+	"LogLevel":                        true,
+	"PackageLogLevels":                true,
+	"LogFormat":                       true,
+	"Pedantic":                        true,
+	"AdminPasswordFile":               true,
+	"Postmaster":                      true,
+	"InitialMailboxes":                true,
+	"DefaultMailboxes":                true,
+	"Transports":                      true,
+	"OutgoingTLSReportsForAllSuccess": true,
+	"QuotaMessageSize":                true,
+	"CompressMessages":                true,
+	"OIDC":                            true,
+	"WebmailRemoteContentProxy":       true,
+	"SpamScan":                        true,
+	"ContentBlocklists":               true,
+	"DNSBLScoring":                    true,
+	"Quarantine":                      true,
+	"SubmissionGuard":                 true,
+	"MetricsSeries":                   true,
+	"QueueWorkers":                    true,
+	"Alerting":                        true,
+	"Tracing":                         true,
+}
+
+// StaticReloaded is called after ReloadStatic applied changed fields, with their
+// names. Set by "mox serve" to reconfigure e.g. log output and tracing.
+var StaticReloaded func(log mlog.Log, changed []string)
+
+// Serializes reloads of the static config.
+var reloadMutex sync.Mutex
+
+// StaticReload describes the changes in the static config file compared to the
+// running configuration.
+type StaticReload struct {
+	Diff    string   // Changed lines since the file was loaded or last reloaded, prefixed with "-" or "+", with unchanged context lines prefixed with a space.
+	Changed []string // Changed fields that take effect without restart.
+	Restart []string // Fields changed since startup that only take effect after a restart, e.g. Listeners.
+	Applied bool     // Whether the changed fields were applied.
+}
+
+// ReloadStatic reads and validates the static config file, along with the
+// dynamic config file, and compares it with the running configuration. If apply
+// is set, fields that can change while running are replaced, and the log levels
+// are reset to those in the file, undoing changes made at runtime. Fields that
+// require a restart are only reported. If the config files have errors, nothing
+// is applied and the running configuration stays in effect.
+func (c *Config) ReloadStatic(ctx context.Context, log mlog.Log, apply bool) (StaticReload, error) {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+
+	if c.staticFile == nil {
+		return StaticReload{}, fmt.Errorf("no config file loaded")
+	}
+
+	nc, errs := ParseConfig(ctx, log, ConfigStaticPath, true, false, false)
+	if len(errs) > 0 {
+		return StaticReload{}, fmt.Errorf("%w: %w", ErrConfig, errors.Join(errs...))
+	}
+
+	prev := c.staticApplied
+	if prev == nil {
+		prev = c.staticFile
+	}
+	changed, err := staticChanged(prev.buf, nc.staticFile.buf)
+	if err != nil {
+		return StaticReload{}, err
+	}
+	changedStartup, err := staticChanged(c.staticFile.buf, nc.staticFile.buf)
+	if err != nil {
+		return StaticReload{}, err
+	}
+	// The diff is of the file without secrets from environment variables and files.
+	r := StaticReload{Diff: lineDiff(string(prev.text), string(nc.staticFile.text))}
+	for _, f := range changed {
+		if staticReloadable[f] {
+			r.Changed = append(r.Changed, f)
+		}
+	}
+	for _, f := range changedStartup {
+		if !staticReloadable[f] {
+			r.Restart = append(r.Restart, f)
+		}
+	}
+	if !apply {
+		return r, nil
+	}
+
+	cv := reflect.ValueOf(&c.Static).Elem()
+	nv := reflect.ValueOf(nc.Static)
+	for _, f := range r.Changed {
+		cv.FieldByName(f).Set(nv.FieldByName(f))
+	}
+	if slices.Contains(r.Changed, "Pedantic") {
+		SetPedantic(c.Static.Pedantic)
+	}
+	c.staticApplied = nc.staticFile
+	r.Applied = true
+
+	c.logMutex.Lock()
+	c.Log = nc.Log
+	mlog.SetConfig(c.Log)
+	c.logMutex.Unlock()
+
+	log.Print("static config reloaded", slog.Any("changed", r.Changed), slog.Any("restartrequired", r.Restart))
+	if StaticReloaded != nil && len(r.Changed) > 0 {
+		StaticReloaded(log, r.Changed)
+	}
+	return r, nil
+}
+
+// staticChanged returns the names of the top-level fields that differ between
+// the static config files a and b, in order of the config.
+func staticChanged(a, b []byte) ([]string, error) {
+	var sa, sb config.Static
+	if err := sconf.Parse(bytes.NewReader(a), &sa); err != nil {
+		return nil, fmt.Errorf("parsing loaded config file: %v", err)
+	}
+	if err := sconf.Parse(bytes.NewReader(b), &sb); err != nil {
+		return nil, fmt.Errorf("parsing config file: %v", err)
+	}
+	va := reflect.ValueOf(sa)
+	vb := reflect.ValueOf(sb)
+	t := va.Type()
+	var l []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Tag.Get("sconf") == "-" {
+			continue
+		}
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			l = append(l, f.Name)
+		}
+	}
+	return l, nil
+}
+
+// lineDiff returns the lines removed from a, prefixed with "-", and added in b,
+// prefixed with "+", with up to 2 unchanged lines of context, prefixed with a
+// space. Skipped unchanged lines are indicated with "...". Config files are small,
+// so a simple longest common subsequence is good enough.
+func lineDiff(a, b string) string {
+	if a == b {
+		return ""
+	}
+	al := strings.Split(strings.TrimSuffix(a, "\n"), "\n")
+	bl := strings.Split(strings.TrimSuffix(b, "\n"), "\n")
+	n, m := len(al), len(bl)
+
+	// lcs[i][j] is the length of the longest common subsequence of al[i:] and bl[j:].
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if al[i] == bl[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	type line struct {
+		op   byte
+		text string
+	}
+	var lines []line
+	i, j := 0, 0
+	for i < n || j < m {
+		if i < n && j < m && al[i] == bl[j] {
+			lines = append(lines, line{' ', al[i]})
+			i++
+			j++
+		} else if i < n && (j == m || lcs[i+1][j] >= lcs[i][j+1]) {
+			lines = append(lines, line{'-', al[i]})
+			i++
+		} else {
+			lines = append(lines, line{'+', bl[j]})
+			j++
+		}
+	}
+
+	const context = 2
+	keep := make([]bool, len(lines))
+	for k, l := range lines {
+		if l.op == ' ' {
+			continue
+		}
+		for x := max(0, k-context); x <= min(len(lines)-1, k+context); x++ {
+			keep[x] = true
+		}
+	}
+	var sb strings.Builder
+	skipped := false
+	for k, l := range lines {
+		if !keep[k] {
+			skipped = true
+			continue
+		}
+		if skipped && sb.Len() > 0 {
+			sb.WriteString("...\n")
+		}
+		skipped = false
+		sb.WriteByte(l.op)
+		sb.WriteString(l.text)
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
@@ -0,0 +1,115 @@
+package mox
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReloadStatic(t *testing.T) {
+	ctx := context.Background()
+	log := pkglog
+
+	dir := t.TempDir()
+	ConfigStaticPath = filepath.Join(dir, "mox.conf")
+	ConfigDynamicPath = filepath.Join(dir, "domains.conf")
+	defer func() {
+		ConfigStaticPath = ""
+		ConfigDynamicPath = ""
+	}()
+
+	const static = `DataDir: data
+User: 1000
+LogLevel: info
+Hostname: mox.example
+Postmaster:
+	Account: mjl
+	Mailbox: postmaster
+Listeners:
+	local: nil
+`
+	const dynamic = `Domains:
+	mox.example: nil
+Accounts:
+	mjl:
+		Domain: mox.example
+		Destinations:
+			mjl@mox.example: nil
+`
+	writeFile := func(p, s string) {
+		t.Helper()
+		err := os.WriteFile(p, []byte(s), 0660)
+		if err != nil {
+			t.Fatalf("write file: %v", err)
+		}
+	}
+	writeFile(ConfigStaticPath, static)
+	writeFile(ConfigDynamicPath, dynamic)
+	if errs := LoadConfig(ctx, log, false, false); len(errs) > 0 {
+		t.Fatalf("load config: %v", errs)
+	}
+
+	check := func(apply bool, expChanged, expRestart []string) StaticReload {
+		t.Helper()
+		r, err := Conf.ReloadStatic(ctx, log, apply)
+		if err != nil {
+			t.Fatalf("reload: %v", err)
+		}
+		if !reflect.DeepEqual(r.Changed, expChanged) || !reflect.DeepEqual(r.Restart, expRestart) || r.Applied != apply {
+			t.Fatalf("reload: got changed %v, restart %v, applied %v, expected %v, %v, %v", r.Changed, r.Restart, r.Applied, expChanged, expRestart, apply)
+		}
+		return r
+	}
+
+	r := check(false, nil, nil)
+	if r.Diff != "" {
+		t.Fatalf("unexpected diff %q", r.Diff)
+	}
+
+	// Log levels changed at runtime are reset by a reload.
+	Conf.LogLevelSet(log, "queue", slog.LevelDebug)
+
+	s := strings.Replace(static, "LogLevel: info", "LogLevel: debug", 1)
+	s += "NoOutgoingDMARCReports: true\nQuotaMessageSize: 1000\n"
+	writeFile(ConfigStaticPath, s)
+
+	r = check(false, []string{"LogLevel", "QuotaMessageSize"}, []string{"NoOutgoingDMARCReports"})
+	if !strings.Contains(r.Diff, "-LogLevel: info\n+LogLevel: debug\n") || !strings.Contains(r.Diff, "+QuotaMessageSize: 1000\n") {
+		t.Fatalf("unexpected diff %q", r.Diff)
+	}
+	if Conf.Static.QuotaMessageSize != 0 || Conf.Static.LogLevel != "info" {
+		t.Fatalf("preview changed config")
+	}
+
+	check(true, []string{"LogLevel", "QuotaMessageSize"}, []string{"NoOutgoingDMARCReports"})
+	if Conf.Static.QuotaMessageSize != 1000 || Conf.Static.LogLevel != "debug" {
+		t.Fatalf("reload did not apply changes")
+	}
+	if Conf.Static.NoOutgoingDMARCReports {
+		t.Fatalf("reload applied field that requires restart")
+	}
+	if l := Conf.LogLevels(); len(l) != 1 || l[""] != slog.LevelDebug {
+		t.Fatalf("log levels not reset: %v", l)
+	}
+
+	// Changes that were applied are no longer reported, fields that need a restart are.
+	r = check(false, nil, []string{"NoOutgoingDMARCReports"})
+	if r.Diff != "" {
+		t.Fatalf("unexpected diff %q", r.Diff)
+	}
+
+	// Invalid config is not applied.
+	writeFile(ConfigStaticPath, strings.Replace(s, "QuotaMessageSize: 1000", "QuotaMessageSize: 2000\nLogFormat: bogus", 1))
+	_, err := Conf.ReloadStatic(ctx, log, true)
+	if err == nil || !errors.Is(err, ErrConfig) {
+		t.Fatalf("reload with invalid config: got err %v, expected config error", err)
+	}
+	if Conf.Static.QuotaMessageSize != 1000 {
+		t.Fatalf("invalid config was applied")
+	}
+}
@@ -0,0 +1,42 @@
+package mox
+
+import (
+	"strings"
+
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/smtp"
+)
+
+// ParseSender parses a sender for allow/deny sender lists, an email address or a
+// domain as "@domain", returning it in canonical form: an address with unicode
+// domain, or "@" with the unicode domain.
+func ParseSender(s string) (string, error) {
+	if ds, ok := strings.CutPrefix(s, "@"); ok {
+		d, err := dns.ParseDomain(ds)
+		if err != nil {
+			return "", err
+		}
+		return "@" + d.Name(), nil
+	}
+	addr, err := smtp.ParseAddress(s)
+	if err != nil {
+		return "", err
+	}
+	return addr.Pack(true), nil
+}
+
+// SenderKeys returns the sender list entries, in canonical form, that match
+// addr, most specific first: the address itself, its domain, and its parent
+// domains with at least two labels.
+func SenderKeys(addr smtp.Address) []string {
+	if addr.IsZero() {
+		return nil
+	}
+	keys := []string{addr.Pack(true)}
+	name := addr.Domain.Name()
+	for strings.Contains(name, ".") {
+		keys = append(keys, "@"+name)
+		_, name, _ = strings.Cut(name, ".")
+	}
+	return keys
+}
@@ -0,0 +1,128 @@
+package mox
+
+import (
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/tlsfp"
+)
+
+var metricTLSFingerprintMatch = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "mox_tls_fingerprint_match_total",
+		Help: "Incoming TLS connections matching a TLS fingerprint rule.",
+	},
+	[]string{
+		"protocol", // smtp, submission, submissions, imap, imaps
+		"action",   // reject, log
+	},
+)
+
+// ErrTLSFingerprintBlocked is returned for TLS handshakes by clients with a
+// fingerprint matching a reject rule.
+var ErrTLSFingerprintBlocked = errors.New("tls client fingerprint blocked")
+
+// TLSFingerprintMatch returns the first configured TLS fingerprint rule matching
+// fp for protocol (smtp, submission, submissions, imap, imaps), if any.
+func TLSFingerprintMatch(protocol string, fp tlsfp.Fingerprint) (config.TLSFingerprintRule, bool) {
+	for _, r := range Conf.Static.TLSFingerprintRules {
+		if len(r.Protocols) > 0 && !slices.Contains(r.Protocols, protocol) {
+			continue
+		}
+		if r.JA3 != "" && r.JA3 == fp.JA3 || r.JA4 != "" && ja4Match(r.JA4, fp.JA4) {
+			return r, true
+		}
+	}
+	return config.TLSFingerprintRule{}, false
+}
+
+// ja4Match returns whether fingerprint fp matches pattern, where each of the
+// underscore-separated parts of pattern can be "*".
+func ja4Match(pattern, fp string) bool {
+	pl := strings.Split(pattern, "_")
+	fl := strings.Split(fp, "_")
+	if len(pl) != len(fl) {
+		return false
+	}
+	for i, p := range pl {
+		if p != "*" && p != fl[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// TLSFingerprintConfig returns a copy of tlsConfig that fails handshakes of
+// clients with a fingerprint matching a reject rule for protocol. Fingerprints
+// are only available for connections wrapped in a tlsfp.Conn before the TLS
+// handshake. Returns nil for a nil tlsConfig.
+func TLSFingerprintConfig(tlsConfig *tls.Config, protocol string) *tls.Config {
+	if tlsConfig == nil {
+		return nil
+	}
+	config := tlsConfig.Clone()
+	config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		fc, ok := hello.Conn.(*tlsfp.Conn)
+		if !ok {
+			return nil, nil
+		}
+		fp, err := fc.Fingerprint()
+		if err != nil {
+			return nil, nil
+		}
+		r, ok := TLSFingerprintMatch(protocol, fp)
+		if !ok {
+			return nil, nil
+		}
+		action := r.Action
+		if action == "" {
+			action = "reject"
+		}
+		metricTLSFingerprintMatch.WithLabelValues(protocol, action).Inc()
+		if action == "reject" {
+			return nil, ErrTLSFingerprintBlocked
+		}
+		return nil, nil
+	}
+	return config
+}
+
+// TLSFingerprintLog logs the fingerprint of the TLS client of conn, which must be
+// a tlsfp.Conn to have a fingerprint, and the rule it matches, if any. Called
+// after a TLS handshake, also after a failed handshake. Returns the zero value if
+// no fingerprint is available.
+func TLSFingerprintLog(log mlog.Log, protocol string, conn net.Conn) tlsfp.Fingerprint {
+	fc, ok := conn.(*tlsfp.Conn)
+	if !ok {
+		return tlsfp.Fingerprint{}
+	}
+	fp, err := fc.Fingerprint()
+	if err != nil {
+		log.Debugx("tls client fingerprint not available", err)
+		return tlsfp.Fingerprint{}
+	}
+	attrs := []slog.Attr{
+		slog.String("ja3", fp.JA3),
+		slog.String("ja4", fp.JA4),
+	}
+	if r, ok := TLSFingerprintMatch(protocol, fp); ok {
+		action := r.Action
+		if action == "" {
+			action = "reject"
+		}
+		attrs = append(attrs, slog.String("action", action), slog.String("comment", r.Comment))
+		log.Info("tls client fingerprint matches rule", attrs...)
+	} else {
+		log.Info("tls client fingerprint", attrs...)
+	}
+	return fp
+}
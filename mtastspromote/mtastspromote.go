@@ -0,0 +1,211 @@
+// Package mtastspromote changes MTA-STS policies of hosted domains from mode
+// testing to mode enforce, after a configured period in which TLS reports
+// (TLSRPT) from remote mail servers have not shown failures.
+//
+// Starting with a policy in mode testing lets remote mail servers report problems
+// with our TLS setup without failing deliveries. Once reports show TLS works,
+// enforcing the policy protects deliveries against downgrade attacks.
+package mtastspromote
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/mox/admindb"
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/mtasts"
+	"github.com/mjl-/mox/tlsrpt"
+	"github.com/mjl-/mox/tlsrptdb"
+)
+
+var metricPromoted = promauto.NewCounter(
+	prometheus.CounterOpts{
+		Name: "mox_mtastspromote_promoted_total",
+		Help: "MTA-STS policies changed from mode testing to mode enforce.",
+	},
+)
+
+// Status is the promotion state of the MTA-STS policy of a domain.
+type Status struct {
+	Domain       string // Unicode.
+	PolicyID     string // Empty if domain has no MTA-STS policy.
+	Mode         mtasts.Mode
+	EnforceAfter time.Duration // Zero if automatic promotion is not configured.
+	TestingStart time.Time     // When the policy was first seen in mode testing with its current policy ID. Zero if not in mode testing or without EnforceAfter.
+	LastFailure  time.Time     // End of period of most recent TLS report with failures since TestingStart. Zero if none.
+	CleanReports int           // Number of TLS reports without failures since TestingStart and LastFailure.
+	PromoteAt    time.Time     // When the policy will be promoted. Zero while no clean TLS report has been received.
+}
+
+// Evaluate returns the promotion status for the MTA-STS policy of domain d. For
+// policies in mode testing with EnforceAfter configured, the start of the
+// testing period for the current policy ID is recorded if not yet known.
+func Evaluate(ctx context.Context, d dns.Domain, now time.Time) (Status, error) {
+	st := Status{Domain: d.Name()}
+	dc, ok := mox.Conf.Domain(d)
+	if !ok {
+		return st, fmt.Errorf("%w: unknown domain", mox.ErrRequest)
+	}
+	sts := dc.MTASTS
+	if sts == nil {
+		return st, nil
+	}
+	st.PolicyID = sts.PolicyID
+	st.Mode = sts.Mode
+	st.EnforceAfter = sts.EnforceAfter
+	if sts.Mode != mtasts.ModeTesting || sts.EnforceAfter <= 0 {
+		return st, nil
+	}
+
+	t, err := admindb.MTASTSTestingEnsure(ctx, d.Name(), sts.PolicyID, now)
+	if err != nil {
+		return st, fmt.Errorf("ensuring testing period: %v", err)
+	}
+	st.TestingStart = t.Start
+
+	records, err := tlsrptdb.RecordsPeriodDomain(ctx, t.Start, now, d)
+	if err != nil {
+		return st, fmt.Errorf("looking up tls reports: %v", err)
+	}
+
+	// Results for our MTA-STS policy in the reports, with the end of the period of
+	// the report.
+	type result struct {
+		end      time.Time
+		failures int64
+	}
+	var results []result
+	for _, r := range records {
+		if r.HostReport {
+			continue
+		}
+		for _, p := range r.Report.Policies {
+			if p.Policy.Type != tlsrpt.STS {
+				continue
+			}
+			if pd, err := dns.ParseDomain(p.Policy.Domain); err != nil || pd != d {
+				continue
+			}
+			results = append(results, result{r.Report.DateRange.End, p.Summary.TotalFailureSessionCount})
+		}
+	}
+	for _, r := range results {
+		if r.failures > 0 && r.end.After(st.LastFailure) {
+			st.LastFailure = r.end
+		}
+	}
+	cleanSince := st.TestingStart
+	if st.LastFailure.After(cleanSince) {
+		cleanSince = st.LastFailure
+	}
+	for _, r := range results {
+		if r.failures == 0 && r.end.After(cleanSince) {
+			st.CleanReports++
+		}
+	}
+	if st.CleanReports > 0 {
+		st.PromoteAt = cleanSince.Add(sts.EnforceAfter)
+	}
+	return st, nil
+}
+
+// Start periodically checks MTA-STS policies in mode testing, and promotes them to
+// mode enforce when due.
+func Start() {
+	log := mlog.New("mtastspromote", nil)
+	go func() {
+		timer := time.NewTimer(time.Minute)
+		defer timer.Stop()
+		for {
+			select {
+			case <-mox.Shutdown.Done():
+				return
+			case <-timer.C:
+			}
+
+			checkAll(log.WithCid(mox.Cid()), time.Now())
+			timer.Reset(time.Hour)
+		}
+	}()
+}
+
+func checkAll(log mlog.Log, now time.Time) {
+	defer func() {
+		x := recover()
+		if x != nil {
+			log.Error("recover from panic", slog.Any("panic", x))
+			debug.PrintStack()
+			metrics.PanicInc(metrics.Mtastspromote)
+		}
+	}()
+
+	ctx := mox.Shutdown
+	for _, name := range mox.Conf.Domains() {
+		d, err := dns.ParseDomain(name)
+		if err != nil {
+			log.Errorx("parsing domain", err, slog.String("domain", name))
+			continue
+		}
+		if dc, ok := mox.Conf.Domain(d); !ok || dc.MTASTS == nil || dc.MTASTS.Mode != mtasts.ModeTesting {
+			// Clean up a testing period, a policy may later be in testing mode again.
+			err := admindb.MTASTSTestingRemove(ctx, d.Name())
+			log.Check(err, "removing mtasts testing period", slog.Any("domain", d))
+			continue
+		}
+		st, err := Evaluate(ctx, d, now)
+		if err != nil {
+			log.Errorx("evaluating mtasts policy for promotion", err, slog.Any("domain", d))
+			continue
+		}
+		if st.PromoteAt.IsZero() || now.Before(st.PromoteAt) {
+			continue
+		}
+		if err := promote(ctx, log, d, st, now); err != nil {
+			log.Errorx("promoting mtasts policy to mode enforce", err, slog.Any("domain", d))
+		}
+	}
+}
+
+// promote changes the policy to mode enforce with a new policy ID, if the policy
+// wasn't changed since it was evaluated.
+func promote(ctx context.Context, log mlog.Log, d dns.Domain, st Status, now time.Time) error {
+	var policyID string
+	err := mox.DomainSave(ctx, d.Name(), func(dc *config.Domain) error {
+		if dc.MTASTS == nil || dc.MTASTS.PolicyID != st.PolicyID || dc.MTASTS.Mode != mtasts.ModeTesting {
+			return nil
+		}
+		sts := *dc.MTASTS
+		sts.Mode = mtasts.ModeEnforce
+		sts.PolicyID = mox.MTASTSPolicyID(now)
+		if sts.PolicyID == st.PolicyID {
+			sts.PolicyID += "a"
+		}
+		policyID = sts.PolicyID
+		dc.MTASTS = &sts
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if policyID == "" {
+		return nil
+	}
+	metricPromoted.Inc()
+	log.Info("mtasts policy promoted to mode enforce, update the dns record with the new policy id",
+		slog.Any("domain", d),
+		slog.String("policyid", policyID),
+		slog.Int("cleanreports", st.CleanReports))
+	err = admindb.MTASTSTestingRemove(ctx, d.Name())
+	log.Check(err, "removing mtasts testing period", slog.Any("domain", d))
+	return nil
+}
@@ -0,0 +1,111 @@
+package mtastspromote
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/admindb"
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/mtasts"
+	"github.com/mjl-/mox/tlsrpt"
+	"github.com/mjl-/mox/tlsrptdb"
+)
+
+var ctxbg = context.Background()
+
+func tcheck(t *testing.T, err error, msg string) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %s", msg, err)
+	}
+}
+
+func TestPromote(t *testing.T) {
+	log := mlog.New("mtastspromote", nil)
+	os.RemoveAll("../testdata/mtastspromote/data")
+	mox.Context = ctxbg
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/mtastspromote/mox.conf")
+	mox.ConfigDynamicPath = filepath.FromSlash("../testdata/mtastspromote/domains.conf")
+	// Promotion writes the domains config, restore it afterwards.
+	domainsConf, err := os.ReadFile(mox.ConfigDynamicPath)
+	tcheck(t, err, "read domains config")
+	defer os.WriteFile(mox.ConfigDynamicPath, domainsConf, 0660)
+	mox.MustLoadConfig(true, false)
+
+	err = admindb.Init()
+	tcheck(t, err, "admindb init")
+	defer admindb.Close()
+	err = tlsrptdb.Init()
+	tcheck(t, err, "tlsrptdb init")
+	defer tlsrptdb.Close()
+
+	d := dns.Domain{ASCII: "mox.example"}
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	addReport := func(id string, start time.Time, failures int64) {
+		t.Helper()
+		r := tlsrpt.Report{
+			OrganizationName: "remote.example",
+			DateRange:        tlsrpt.TLSRPTDateRange{Start: start, End: start.Add(day)},
+			ReportID:         id,
+			Policies: []tlsrpt.Result{{
+				Policy:  tlsrpt.ResultPolicy{Type: tlsrpt.STS, Domain: "mox.example"},
+				Summary: tlsrpt.Summary{TotalSuccessfulSessionCount: 10, TotalFailureSessionCount: failures},
+			}},
+		}
+		err := tlsrptdb.AddReport(ctxbg, log, dns.Domain{ASCII: "remote.example"}, "tlsrpt@remote.example", false, &r)
+		tcheck(t, err, "add report")
+	}
+
+	evaluate := func(now time.Time, expClean int, expPromoteAt time.Time) Status {
+		t.Helper()
+		st, err := Evaluate(ctxbg, d, now)
+		tcheck(t, err, "evaluate")
+		if st.CleanReports != expClean || !st.PromoteAt.Equal(expPromoteAt) {
+			t.Fatalf("evaluate, got %d clean reports, promote at %s, expected %d and %s", st.CleanReports, st.PromoteAt, expClean, expPromoteAt)
+		}
+		return st
+	}
+
+	// Start of testing period is recorded, no reports yet.
+	st := evaluate(t0, 0, time.Time{})
+	if !st.TestingStart.Equal(t0) || st.Mode != mtasts.ModeTesting {
+		t.Fatalf("got status %#v, expected testing mode starting at %s", st, t0)
+	}
+
+	// Clean report starts the countdown from the start of the testing period.
+	addReport("1", t0.Add(day), 0)
+	evaluate(t0.Add(3*day), 1, t0.Add(14*day))
+
+	// Report with failures restarts the countdown.
+	addReport("2", t0.Add(4*day), 3)
+	st = evaluate(t0.Add(6*day), 0, time.Time{})
+	if !st.LastFailure.Equal(t0.Add(5 * day)) {
+		t.Fatalf("got last failure %s, expected %s", st.LastFailure, t0.Add(5*day))
+	}
+	addReport("3", t0.Add(6*day), 0)
+	evaluate(t0.Add(8*day), 1, t0.Add(19*day))
+
+	// Not yet due.
+	checkAll(log, t0.Add(18*day))
+	if dc, _ := mox.Conf.Domain(d); dc.MTASTS.Mode != mtasts.ModeTesting {
+		t.Fatalf("policy promoted before due")
+	}
+
+	// Promoted, with a new policy ID.
+	checkAll(log, t0.Add(20*day))
+	dc, _ := mox.Conf.Domain(d)
+	if dc.MTASTS.Mode != mtasts.ModeEnforce || dc.MTASTS.PolicyID != mox.MTASTSPolicyID(t0.Add(20*day)) {
+		t.Fatalf("got mode %s, policy id %s, expected enforce with new policy id", dc.MTASTS.Mode, dc.MTASTS.PolicyID)
+	}
+	st = evaluate(t0.Add(20*day), 0, time.Time{})
+	if st.Mode != mtasts.ModeEnforce || !st.TestingStart.IsZero() {
+		t.Fatalf("got status %#v after promotion", st)
+	}
+}
@@ -0,0 +1,435 @@
+// Package ocsp fetches OCSP responses, RFC 6960, for stapling to TLS handshakes
+// with static (non-ACME) certificates.
+//
+// With stapling, TLS clients get a recent signed statement from the CA that the
+// certificate has not been revoked, without having to contact the OCSP responder
+// of the CA themselves.
+package ocsp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+)
+
+var metricFetch = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "mox_ocsp_fetch_total",
+		Help: "Number of OCSP responses fetched for stapling, by result.",
+	},
+	[]string{
+		"result", // ok, revoked, error
+	},
+)
+
+var (
+	oidSHA1      = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidBasic     = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+	errNoOCSP    = errors.New("certificate has no ocsp responder")
+	errNoIssuer  = errors.New("no issuer certificate after leaf certificate")
+	errMalformed = errors.New("malformed ocsp response")
+)
+
+// Status of a certificate in an OCSP response.
+type Status int
+
+const (
+	Good Status = iota
+	Revoked
+	Unknown
+)
+
+func (s Status) String() string {
+	switch s {
+	case Good:
+		return "good"
+	case Revoked:
+		return "revoked"
+	}
+	return "unknown"
+}
+
+// Response is a parsed and verified OCSP response for a single certificate.
+type Response struct {
+	Status     Status
+	ProducedAt time.Time
+	ThisUpdate time.Time
+	NextUpdate time.Time // Can be zero, if the responder always has newer information.
+	RevokedAt  time.Time // Only for Revoked.
+}
+
+// refreshAt returns when a new response should be fetched: halfway the validity
+// period, or after a day for responses without next update.
+func (r Response) refreshAt() time.Time {
+	if r.NextUpdate.IsZero() {
+		return r.ThisUpdate.Add(24 * time.Hour)
+	}
+	return r.ThisUpdate.Add(r.NextUpdate.Sub(r.ThisUpdate) / 2)
+}
+
+// ASN.1 structures from RFC 6960 section 4.1.1 and 4.2.1.
+
+type certID struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	NameHash      []byte
+	IssuerKeyHash []byte
+	SerialNumber  *big.Int
+}
+
+type request struct {
+	Cert certID
+}
+
+type tbsRequest struct {
+	RequestList []request
+}
+
+type ocspRequest struct {
+	TBSRequest tbsRequest
+}
+
+type responseBytes struct {
+	ResponseType asn1.ObjectIdentifier
+	Response     []byte
+}
+
+type ocspResponse struct {
+	Status   asn1.Enumerated
+	Response responseBytes `asn1:"explicit,tag:0,optional"`
+}
+
+type basicResponse struct {
+	TBSResponseData    responseData
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type responseData struct {
+	Raw         asn1.RawContent
+	Version     int `asn1:"optional,default:0,explicit,tag:0"`
+	ResponderID asn1.RawValue
+	ProducedAt  time.Time `asn1:"generalized"`
+	Responses   []singleResponse
+	Extensions  []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type revokedInfo struct {
+	RevocationTime time.Time       `asn1:"generalized"`
+	Reason         asn1.Enumerated `asn1:"explicit,tag:0,optional"`
+}
+
+type singleResponse struct {
+	CertID     certID
+	Good       asn1.Flag        `asn1:"tag:0,optional"`
+	Revoked    revokedInfo      `asn1:"tag:1,optional"`
+	Unknown    asn1.Flag        `asn1:"tag:2,optional"`
+	ThisUpdate time.Time        `asn1:"generalized"`
+	NextUpdate time.Time        `asn1:"generalized,explicit,tag:0,optional"`
+	Extensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+// makeCertID returns the CertID for leaf, with SHA-1 hashes as used by all
+// common responders.
+func makeCertID(leaf, issuer *x509.Certificate) (certID, error) {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return certID{}, fmt.Errorf("parsing issuer public key: %v", err)
+	}
+	nameHash := sha1.Sum(issuer.RawSubject)
+	keyHash := sha1.Sum(spki.PublicKey.RightAlign())
+	id := certID{
+		HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.NullRawValue},
+		NameHash:      nameHash[:],
+		IssuerKeyHash: keyHash[:],
+		SerialNumber:  leaf.SerialNumber,
+	}
+	return id, nil
+}
+
+// Request returns a DER-encoded OCSP request for leaf.
+func Request(leaf, issuer *x509.Certificate) ([]byte, error) {
+	id, err := makeCertID(leaf, issuer)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(ocspRequest{tbsRequest{[]request{{id}}}})
+}
+
+// signatureAlgorithms maps the signature algorithms commonly used by OCSP
+// responders.
+var signatureAlgorithms = map[string]x509.SignatureAlgorithm{
+	"1.2.840.113549.1.1.11": x509.SHA256WithRSA,
+	"1.2.840.113549.1.1.12": x509.SHA384WithRSA,
+	"1.2.840.113549.1.1.13": x509.SHA512WithRSA,
+	"1.2.840.10045.4.3.2":   x509.ECDSAWithSHA256,
+	"1.2.840.10045.4.3.3":   x509.ECDSAWithSHA384,
+	"1.2.840.10045.4.3.4":   x509.ECDSAWithSHA512,
+	"1.3.101.112":           x509.PureEd25519,
+}
+
+// ParseResponse parses a DER-encoded OCSP response for leaf, and verifies it is
+// signed by the issuer, or by a responder certificate issued by the issuer for
+// signing OCSP responses.
+func ParseResponse(der []byte, leaf, issuer *x509.Certificate) (Response, error) {
+	var resp ocspResponse
+	if rest, err := asn1.Unmarshal(der, &resp); err != nil {
+		return Response{}, fmt.Errorf("%w: %v", errMalformed, err)
+	} else if len(rest) > 0 {
+		return Response{}, fmt.Errorf("%w: trailing data", errMalformed)
+	}
+	if resp.Status != 0 {
+		return Response{}, fmt.Errorf("ocsp responder returned error status %d", resp.Status)
+	}
+	if !resp.Response.ResponseType.Equal(oidBasic) {
+		return Response{}, fmt.Errorf("unsupported ocsp response type %s", resp.Response.ResponseType)
+	}
+	var basic basicResponse
+	if _, err := asn1.Unmarshal(resp.Response.Response, &basic); err != nil {
+		return Response{}, fmt.Errorf("%w: basic response: %v", errMalformed, err)
+	}
+
+	signer := issuer
+	if len(basic.Certificates) > 0 {
+		// Delegated responder, RFC 6960 section 4.2.2.2.
+		c, err := x509.ParseCertificate(basic.Certificates[0].FullBytes)
+		if err != nil {
+			return Response{}, fmt.Errorf("parsing responder certificate: %v", err)
+		}
+		if !bytes.Equal(c.Raw, issuer.Raw) {
+			if err := c.CheckSignatureFrom(issuer); err != nil {
+				return Response{}, fmt.Errorf("responder certificate not signed by issuer: %v", err)
+			}
+			var ok bool
+			for _, eku := range c.ExtKeyUsage {
+				ok = ok || eku == x509.ExtKeyUsageOCSPSigning
+			}
+			if !ok {
+				return Response{}, fmt.Errorf("responder certificate not allowed to sign ocsp responses")
+			}
+			signer = c
+		}
+	}
+	alg, ok := signatureAlgorithms[basic.SignatureAlgorithm.Algorithm.String()]
+	if !ok {
+		return Response{}, fmt.Errorf("unsupported signature algorithm %s", basic.SignatureAlgorithm.Algorithm)
+	}
+	if err := signer.CheckSignature(alg, basic.TBSResponseData.Raw, basic.Signature.RightAlign()); err != nil {
+		return Response{}, fmt.Errorf("verifying signature: %v", err)
+	}
+
+	id, err := makeCertID(leaf, issuer)
+	if err != nil {
+		return Response{}, err
+	}
+	for _, sr := range basic.TBSResponseData.Responses {
+		if sr.CertID.SerialNumber == nil || sr.CertID.SerialNumber.Cmp(leaf.SerialNumber) != 0 {
+			continue
+		}
+		if sr.CertID.HashAlgorithm.Algorithm.Equal(oidSHA1) && (!bytes.Equal(sr.CertID.NameHash, id.NameHash) || !bytes.Equal(sr.CertID.IssuerKeyHash, id.IssuerKeyHash)) {
+			continue
+		}
+		r := Response{
+			Status:     Unknown,
+			ProducedAt: basic.TBSResponseData.ProducedAt,
+			ThisUpdate: sr.ThisUpdate,
+			NextUpdate: sr.NextUpdate,
+		}
+		if sr.Good {
+			r.Status = Good
+		} else if !sr.Revoked.RevocationTime.IsZero() {
+			r.Status = Revoked
+			r.RevokedAt = sr.Revoked.RevocationTime
+		}
+		return r, nil
+	}
+	return Response{}, fmt.Errorf("no response for certificate in ocsp response")
+}
+
+// Fetch requests the status of leaf from its OCSP responder, returning the
+// DER-encoded response for stapling and the parsed response.
+func Fetch(ctx context.Context, leaf, issuer *x509.Certificate) ([]byte, Response, error) {
+	if len(leaf.OCSPServer) == 0 {
+		return nil, Response{}, errNoOCSP
+	}
+	reqBuf, err := Request(leaf, issuer)
+	if err != nil {
+		return nil, Response{}, fmt.Errorf("making request: %v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", leaf.OCSPServer[0], bytes.NewReader(reqBuf))
+	if err != nil {
+		return nil, Response{}, fmt.Errorf("new request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/ocsp-request")
+	req.Header.Set("Accept", "application/ocsp-response")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, Response{}, fmt.Errorf("http request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, Response{}, fmt.Errorf("http response status %s", resp.Status)
+	}
+	buf, err := io.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+	if err != nil {
+		return nil, Response{}, fmt.Errorf("reading response: %v", err)
+	}
+	r, err := ParseResponse(buf, leaf, issuer)
+	if err != nil {
+		return nil, Response{}, err
+	}
+	return buf, r, nil
+}
+
+// Stapler keeps OCSP responses for static certificates, and provides them during
+// TLS handshakes.
+type Stapler struct {
+	name  string // For logging, e.g. the listener.
+	certs atomic.Pointer[[]tls.Certificate]
+
+	sync.Mutex
+	responses []Response // Per certificate, for the current staple.
+}
+
+// NewStapler returns a stapler for certs. No OCSP responses are fetched until
+// Refresh or Start is called.
+func NewStapler(name string, certs []tls.Certificate) *Stapler {
+	s := &Stapler{name: name, responses: make([]Response, len(certs))}
+	l := append([]tls.Certificate{}, certs...)
+	s.certs.Store(&l)
+	return s
+}
+
+// GetCertificate returns the first certificate supported by the client, with
+// OCSP staple if available, for use in tls.Config.GetCertificate.
+func (s *Stapler) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certs := *s.certs.Load()
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates")
+	}
+	for i := range certs {
+		if hello.SupportsCertificate(&certs[i]) == nil {
+			return &certs[i], nil
+		}
+	}
+	return &certs[0], nil
+}
+
+// Start refreshes OCSP responses in the background, until shutdown.
+func (s *Stapler) Start(log mlog.Log, shutdown <-chan struct{}) {
+	go func() {
+		defer func() {
+			x := recover()
+			if x != nil {
+				log.Error("recover from panic", slog.Any("panic", x))
+				debug.PrintStack()
+				metrics.PanicInc(metrics.OCSP)
+			}
+		}()
+
+		timer := time.NewTimer(0)
+		defer timer.Stop()
+		for {
+			select {
+			case <-shutdown:
+				return
+			case <-timer.C:
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			s.Refresh(ctx, log)
+			cancel()
+			timer.Reset(time.Hour)
+		}
+	}()
+}
+
+// Refresh fetches new OCSP responses for certificates that don't have a response,
+// or with a response that is past halfway its validity period. Responses that
+// could not be refreshed are kept until they expire. Certificates that are
+// revoked get no staple.
+func (s *Stapler) Refresh(ctx context.Context, log mlog.Log) {
+	s.Lock()
+	defer s.Unlock()
+
+	log = log.With(slog.String("name", s.name))
+	now := time.Now()
+	certs := append([]tls.Certificate{}, *s.certs.Load()...)
+	changed := false
+	for i := range certs {
+		r := s.responses[i]
+		if certs[i].OCSPStaple != nil && now.Before(r.refreshAt()) {
+			continue
+		}
+		if len(certs[i].Certificate) == 0 {
+			continue
+		}
+		leaf, err := x509.ParseCertificate(certs[i].Certificate[0])
+		if err != nil {
+			log.Errorx("parsing certificate for ocsp", err)
+			continue
+		}
+		if len(leaf.OCSPServer) == 0 {
+			continue
+		} else if len(certs[i].Certificate) < 2 {
+			log.Errorx("cannot fetch ocsp response", errNoIssuer, slog.Any("subject", leaf.Subject))
+			continue
+		}
+		issuer, err := x509.ParseCertificate(certs[i].Certificate[1])
+		if err != nil {
+			log.Errorx("parsing issuer certificate for ocsp", err)
+			continue
+		}
+
+		staple, nr, err := Fetch(ctx, leaf, issuer)
+		if err == nil && nr.Status == Revoked {
+			metricFetch.WithLabelValues("revoked").Inc()
+			log.Error("certificate revoked according to ocsp responder, not stapling", slog.Any("subject", leaf.Subject), slog.Time("revoked", nr.RevokedAt))
+			staple = nil
+		} else if err == nil && nr.Status != Good {
+			metricFetch.WithLabelValues("error").Inc()
+			log.Error("ocsp responder does not know certificate, not stapling", slog.Any("subject", leaf.Subject))
+			staple = nil
+		} else if err != nil {
+			metricFetch.WithLabelValues("error").Inc()
+			log.Errorx("fetching ocsp response", err, slog.Any("subject", leaf.Subject))
+			if certs[i].OCSPStaple != nil && !r.NextUpdate.IsZero() && now.After(r.NextUpdate) {
+				certs[i].OCSPStaple = nil
+				changed = true
+			}
+			continue
+		} else {
+			metricFetch.WithLabelValues("ok").Inc()
+			log.Debug("new ocsp response", slog.Any("subject", leaf.Subject), slog.Time("nextupdate", nr.NextUpdate))
+		}
+		certs[i].OCSPStaple = staple
+		s.responses[i] = nr
+		changed = true
+	}
+	if changed {
+		s.certs.Store(&certs)
+	}
+}
@@ -0,0 +1,210 @@
+package ocsp
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/mlog"
+)
+
+func tcheck(t *testing.T, err error, msg string) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %s", msg, err)
+	}
+}
+
+// responder is a fake OCSP responder, signing responses with the CA key.
+type responder struct {
+	sync.Mutex
+	status  Status
+	badSig  bool
+	fetches int
+
+	ca    *x509.Certificate
+	caKey *ecdsa.PrivateKey
+}
+
+func (rs *responder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rs.Lock()
+	defer rs.Unlock()
+	rs.fetches++
+
+	buf, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "read", http.StatusBadRequest)
+		return
+	}
+	var req ocspRequest
+	if _, err := asn1.Unmarshal(buf, &req); err != nil || len(req.TBSRequest.RequestList) != 1 {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	sr := singleResponse{
+		CertID:     req.TBSRequest.RequestList[0].Cert,
+		ThisUpdate: now.Add(-time.Hour),
+		NextUpdate: now.Add(7 * 24 * time.Hour),
+	}
+	switch rs.status {
+	case Good:
+		sr.Good = true
+	case Revoked:
+		sr.Revoked = revokedInfo{RevocationTime: now.Add(-2 * time.Hour)}
+	default:
+		sr.Unknown = true
+	}
+	tbs := responseData{
+		ResponderID: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 1, IsCompound: true, Bytes: rs.ca.RawSubject},
+		ProducedAt:  now,
+		Responses:   []singleResponse{sr},
+	}
+	tbsBuf, err := asn1.Marshal(tbs)
+	if err != nil {
+		http.Error(w, "marshal", http.StatusInternalServerError)
+		return
+	}
+	digest := sha256.Sum256(tbsBuf)
+	sig, err := ecdsa.SignASN1(cryptorand.Reader, rs.caKey, digest[:])
+	if err != nil {
+		http.Error(w, "sign", http.StatusInternalServerError)
+		return
+	}
+	if rs.badSig {
+		sig[len(sig)-1] ^= 1
+	}
+	basic := basicResponse{
+		TBSResponseData:    tbs,
+		SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}},
+		Signature:          asn1.BitString{Bytes: sig, BitLength: 8 * len(sig)},
+	}
+	basicBuf, err := asn1.Marshal(basic)
+	if err != nil {
+		http.Error(w, "marshal", http.StatusInternalServerError)
+		return
+	}
+	respBuf, err := asn1.Marshal(ocspResponse{Response: responseBytes{oidBasic, basicBuf}})
+	if err != nil {
+		http.Error(w, "marshal", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	w.Write(respBuf)
+}
+
+func TestStapler(t *testing.T) {
+	log := mlog.New("ocsp", nil)
+	ctx := context.Background()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	tcheck(t, err, "generate ca key")
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(cryptorand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	tcheck(t, err, "create ca certificate")
+	ca, err := x509.ParseCertificate(caDER)
+	tcheck(t, err, "parse ca certificate")
+
+	rs := &responder{ca: ca, caKey: caKey}
+	srv := httptest.NewServer(rs)
+	defer srv.Close()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	tcheck(t, err, "generate key")
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1234),
+		Subject:      pkix.Name{CommonName: "mox.example"},
+		DNSNames:     []string{"mox.example"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		OCSPServer:   []string{srv.URL},
+	}
+	leafDER, err := x509.CreateCertificate(cryptorand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	tcheck(t, err, "create certificate")
+	leaf, err := x509.ParseCertificate(leafDER)
+	tcheck(t, err, "parse certificate")
+
+	staple, r, err := Fetch(ctx, leaf, ca)
+	tcheck(t, err, "fetch")
+	if r.Status != Good || len(staple) == 0 || r.NextUpdate.IsZero() {
+		t.Fatalf("fetch, got status %s, staple %d bytes, next update %s", r.Status, len(staple), r.NextUpdate)
+	}
+
+	s := NewStapler("test", []tls.Certificate{{Certificate: [][]byte{leafDER, caDER}, PrivateKey: key}})
+	hello := &tls.ClientHelloInfo{
+		ServerName:        "mox.example",
+		CipherSuites:      []uint16{tls.TLS_AES_128_GCM_SHA256},
+		SupportedCurves:   []tls.CurveID{tls.CurveP256},
+		SignatureSchemes:  []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+		SupportedVersions: []uint16{tls.VersionTLS13},
+	}
+	cert, err := s.GetCertificate(hello)
+	tcheck(t, err, "get certificate")
+	if cert.OCSPStaple != nil {
+		t.Fatalf("staple before refresh")
+	}
+
+	s.Refresh(ctx, log)
+	cert, err = s.GetCertificate(hello)
+	tcheck(t, err, "get certificate")
+	if cert.OCSPStaple == nil {
+		t.Fatalf("no staple after refresh")
+	}
+
+	// Response is recent, no new fetch.
+	s.Refresh(ctx, log)
+	rs.Lock()
+	if rs.fetches != 2 {
+		t.Fatalf("got %d fetches, expected 2", rs.fetches)
+	}
+	rs.Unlock()
+
+	// Bad signature is rejected.
+	rs.Lock()
+	rs.badSig = true
+	rs.Unlock()
+	if _, _, err := Fetch(ctx, leaf, ca); err == nil {
+		t.Fatalf("fetch with bad signature succeeded")
+	}
+
+	// Revoked certificates get no staple.
+	rs.Lock()
+	rs.badSig = false
+	rs.status = Revoked
+	rs.Unlock()
+	_, r, err = Fetch(ctx, leaf, ca)
+	tcheck(t, err, "fetch revoked")
+	if r.Status != Revoked || r.RevokedAt.IsZero() {
+		t.Fatalf("got status %s, revoked at %s, expected revoked", r.Status, r.RevokedAt)
+	}
+	s.Lock()
+	s.responses[0] = Response{}
+	s.Unlock()
+	s.Refresh(ctx, log)
+	cert, err = s.GetCertificate(hello)
+	tcheck(t, err, "get certificate")
+	if cert.OCSPStaple != nil {
+		t.Fatalf("staple for revoked certificate")
+	}
+}
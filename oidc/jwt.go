@@ -0,0 +1,201 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+var errSignature = errors.New("bad signature")
+
+// jwk is a public key from a JSON Web Key Set, RFC 7517 section 4.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+
+	// RSA, RFC 7518 section 6.3.1.
+	N string `json:"n"`
+	E string `json:"e"`
+
+	// EC and OKP, RFC 7518 section 6.2.1 and RFC 8037 section 2.
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey returns the parsed public key, of type *rsa.PublicKey,
+// *ecdsa.PublicKey or ed25519.PublicKey.
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	dec := base64.RawURLEncoding.DecodeString
+	switch k.Kty {
+	case "RSA":
+		n, err := dec(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("decoding rsa modulus: %v", err)
+		}
+		e, err := dec(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("decoding rsa exponent: %v", err)
+		}
+		if len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("bad rsa exponent")
+		}
+		pk := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if pk.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("rsa key too small, %d bits", pk.N.BitLen())
+		}
+		return pk, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := dec(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("decoding x: %v", err)
+		}
+		y, err := dec(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("decoding y: %v", err)
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		if len(x) != size || len(y) != size {
+			return nil, fmt.Errorf("bad coordinate size")
+		}
+		pk := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(pk.X, pk.Y) {
+			return nil, fmt.Errorf("point not on curve")
+		}
+		return pk, nil
+
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := dec(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("decoding x: %v", err)
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("bad ed25519 key size")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// jwtHeader is the JOSE header of a JWT, RFC 7515 section 4.
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ"`
+}
+
+// jwtParse parses a JWT in compact serialization, RFC 7519 section 7.2, without
+// verifying the signature.
+func jwtParse(token string) (hdr jwtHeader, claims map[string]any, signed, sig []byte, rerr error) {
+	t := strings.Split(token, ".")
+	if len(t) != 3 {
+		return hdr, nil, nil, nil, fmt.Errorf("%w: not a jwt", ErrToken)
+	}
+	hbuf, err := base64.RawURLEncoding.DecodeString(t[0])
+	if err != nil {
+		return hdr, nil, nil, nil, fmt.Errorf("%w: decoding header: %v", ErrToken, err)
+	}
+	if err := json.Unmarshal(hbuf, &hdr); err != nil {
+		return hdr, nil, nil, nil, fmt.Errorf("%w: parsing header: %v", ErrToken, err)
+	}
+	pbuf, err := base64.RawURLEncoding.DecodeString(t[1])
+	if err != nil {
+		return hdr, nil, nil, nil, fmt.Errorf("%w: decoding payload: %v", ErrToken, err)
+	}
+	if err := json.Unmarshal(pbuf, &claims); err != nil {
+		return hdr, nil, nil, nil, fmt.Errorf("%w: parsing claims: %v", ErrToken, err)
+	}
+	sig, err = base64.RawURLEncoding.DecodeString(t[2])
+	if err != nil {
+		return hdr, nil, nil, nil, fmt.Errorf("%w: decoding signature: %v", ErrToken, err)
+	}
+	return hdr, claims, []byte(t[0] + "." + t[1]), sig, nil
+}
+
+// jwsVerify verifies a signature with algorithm alg, RFC 7518 section 3.1. The
+// unsecured "none" algorithm and HMAC algorithms are not supported.
+func jwsVerify(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "PS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "PS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "PS512", "ES512":
+		hash = crypto.SHA512
+	case "EdDSA":
+		pk, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return fmt.Errorf("key type %T does not match algorithm %s", key, alg)
+		}
+		if !ed25519.Verify(pk, signed, sig) {
+			return errSignature
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch alg[0] {
+	case 'R', 'P':
+		pk, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key type %T does not match algorithm %s", key, alg)
+		}
+		var err error
+		if alg[0] == 'R' {
+			err = rsa.VerifyPKCS1v15(pk, hash, digest, sig)
+		} else {
+			err = rsa.VerifyPSS(pk, hash, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+		if err != nil {
+			return errSignature
+		}
+	case 'E':
+		pk, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key type %T does not match algorithm %s", key, alg)
+		}
+		// Signature is the concatenation of fixed-size r and s, RFC 7518 section 3.4.
+		size := (pk.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return errSignature
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pk, digest, r, s) {
+			return errSignature
+		}
+	}
+	return nil
+}
@@ -0,0 +1,480 @@
+// Package oidc implements an OpenID Connect relying party, for single sign-on
+// with an external identity provider, and verification of OAuth 2.0 access
+// tokens issued by that identity provider.
+//
+// Web logins use the authorization code flow with PKCE, RFC 7636. The ID token
+// from the token endpoint is verified with the keys of the identity provider.
+// Access tokens, as used by email clients for SASL mechanisms OAUTHBEARER and
+// XOAUTH2, are verified as JWT, RFC 9068, or through token introspection, RFC
+// 7662, when the token is not a JWT.
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/moxvar"
+	"github.com/mjl-/mox/stub"
+)
+
+var (
+	HTTPClientObserve func(ctx context.Context, log *slog.Logger, pkg, method string, statusCode int, err error, start time.Time) = stub.HTTPClientObserveIgnore
+)
+
+// ErrToken is returned when a token is not valid, e.g. expired, for another
+// audience, or with a bad signature. Other errors indicate a problem with the
+// identity provider, e.g. because it cannot be reached.
+var ErrToken = errors.New("invalid token")
+
+// HTTPClient is used for requests to the identity provider.
+var HTTPClient = &http.Client{
+	Timeout: 30 * time.Second,
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return errors.New("redirect not allowed")
+	},
+}
+
+// Allowed difference between our clock and that of the identity provider.
+const clockSkew = 2 * time.Minute
+
+// Intervals for fetching the configuration and keys of the identity provider.
+// Keys are fetched early when a token references an unknown key, but not more
+// often than keysMinInterval, to prevent unknown keys from causing a request for
+// each token.
+const (
+	metadataInterval = 24 * time.Hour
+	keysInterval     = 24 * time.Hour
+	keysMinInterval  = time.Minute
+)
+
+// metadata is the configuration of an OpenID Provider, from
+// /.well-known/openid-configuration, OpenID Connect Discovery 1.0 section 3.
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+	IntrospectionEndpoint string `json:"introspection_endpoint"` // RFC 8414 section 2.
+}
+
+// Provider is an OpenID Connect identity provider, with its configuration and
+// keys fetched on demand and cached.
+type Provider struct {
+	config config.OIDC
+
+	sync.Mutex
+	meta     *metadata
+	metaTime time.Time
+	keys     map[string]jwk // Keyed by kid.
+	keysTime time.Time
+}
+
+// New returns a provider for the configuration. No requests are made until the
+// provider is used.
+func New(c config.OIDC) *Provider {
+	return &Provider{config: c}
+}
+
+var providerCache = struct {
+	sync.Mutex
+	config   *config.OIDC
+	provider *Provider
+}{}
+
+// Get returns the provider for the configuration, keeping the provider, and its
+// cached keys, for as long as the same configuration is passed. Get returns nil
+// if c is nil.
+func Get(c *config.OIDC) *Provider {
+	if c == nil {
+		return nil
+	}
+	providerCache.Lock()
+	defer providerCache.Unlock()
+	if providerCache.config != c {
+		providerCache.config = c
+		providerCache.provider = New(*c)
+	}
+	return providerCache.provider
+}
+
+// ConfigurationURL returns the URL of the configuration document of the
+// identity provider, for clients to discover how to request tokens.
+func (p *Provider) ConfigurationURL() string {
+	return strings.TrimSuffix(p.config.Issuer, "/") + "/.well-known/openid-configuration"
+}
+
+// CodeChallenge returns the PKCE code challenge for a verifier, with method S256,
+// RFC 7636 section 4.2.
+func CodeChallenge(verifier string) string {
+	h := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
+
+// AuthURL returns the URL to send the user to for logging in at the identity
+// provider. After login, the user is sent back to redirectURI, with the
+// authorization code and state as query string parameters.
+func (p *Provider) AuthURL(ctx context.Context, log mlog.Log, redirectURI, state, nonce, codeVerifier string) (string, error) {
+	meta, err := p.metadata(ctx, log)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(meta.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("parsing authorization endpoint: %v", err)
+	}
+	scopes := append([]string{"openid", "email", "profile"}, p.config.Scopes...)
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.config.ClientID)
+	q.Set("redirect_uri", redirectURI)
+	q.Set("scope", strings.Join(scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", CodeChallenge(codeVerifier))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Exchange requests tokens for an authorization code at the token endpoint, and
+// returns the email address from the verified ID token. The nonce must match the
+// nonce passed to AuthURL.
+func (p *Provider) Exchange(ctx context.Context, log mlog.Log, code, redirectURI, codeVerifier, nonce string) (email string, rerr error) {
+	meta, err := p.metadata(ctx, log)
+	if err != nil {
+		return "", err
+	}
+
+	// RFC 6749 section 4.1.3.
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURI)
+	form.Set("code_verifier", codeVerifier)
+	var resp struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := p.post(ctx, log, meta.TokenEndpoint, form, &resp); err != nil {
+		return "", fmt.Errorf("token request: %w", err)
+	}
+	if resp.Error != "" {
+		return "", fmt.Errorf("%w: token request: %s: %s", ErrToken, resp.Error, resp.ErrorDescription)
+	}
+	if resp.IDToken == "" {
+		return "", fmt.Errorf("token response without id token")
+	}
+
+	claims, err := p.verifyJWT(ctx, log, resp.IDToken, []string{p.config.ClientID}, false)
+	if err != nil {
+		return "", err
+	}
+	// OpenID Connect Core 1.0 section 3.1.3.7.
+	if s, _ := claims["nonce"].(string); s != nonce {
+		return "", fmt.Errorf("%w: nonce mismatch", ErrToken)
+	}
+	if azp, ok := claims["azp"].(string); ok && azp != p.config.ClientID {
+		return "", fmt.Errorf("%w: authorized party %q is not our client id", ErrToken, azp)
+	}
+	return p.email(claims)
+}
+
+// VerifyAccessToken verifies an OAuth 2.0 access token and returns the email
+// address it was issued for. The token must have one of the configured
+// audiences, there is no default: the client ID is the audience of ID tokens. If
+// the token is not valid, an error wrapping ErrToken is returned.
+func (p *Provider) VerifyAccessToken(ctx context.Context, log mlog.Log, token string) (email string, rerr error) {
+	if len(p.config.Audiences) == 0 {
+		return "", fmt.Errorf("%w: no audiences configured for access tokens", ErrToken)
+	}
+
+	if strings.Count(token, ".") == 2 {
+		claims, err := p.verifyJWT(ctx, log, token, p.config.Audiences, true)
+		if err != nil {
+			return "", err
+		}
+		return p.email(claims)
+	}
+
+	// Opaque token, ask the identity provider, RFC 7662 section 2.
+	meta, err := p.metadata(ctx, log)
+	if err != nil {
+		return "", err
+	}
+	if meta.IntrospectionEndpoint == "" || p.config.ClientSecret == "" {
+		return "", fmt.Errorf("%w: access token is not a jwt, and token introspection is not available", ErrToken)
+	}
+	var claims map[string]any
+	if err := p.post(ctx, log, meta.IntrospectionEndpoint, url.Values{"token": {token}}, &claims); err != nil {
+		return "", fmt.Errorf("token introspection: %w", err)
+	}
+	if active, _ := claims["active"].(bool); !active {
+		return "", fmt.Errorf("%w: token not active", ErrToken)
+	}
+	if err := p.checkClaims(claims, p.config.Audiences); err != nil {
+		return "", err
+	}
+	return p.email(claims)
+}
+
+// email returns the email address from the claims.
+func (p *Provider) email(claims map[string]any) (string, error) {
+	name := p.config.EmailClaimEffective()
+	email, _ := claims[name].(string)
+	if email == "" {
+		return "", fmt.Errorf("%w: missing claim %q with email address", ErrToken, name)
+	}
+	// Some identity providers send the boolean as string.
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		if !v {
+			return "", fmt.Errorf("%w: email address not verified", ErrToken)
+		}
+	case string:
+		if v == "false" {
+			return "", fmt.Errorf("%w: email address not verified", ErrToken)
+		}
+	}
+	return email, nil
+}
+
+// verifyJWT verifies the signature and claims of a JWT, returning its claims. For
+// access tokens, the JWT must have type at+jwt, so other JWTs from the issuer,
+// such as ID tokens, are not accepted, RFC 9068 section 4.
+func (p *Provider) verifyJWT(ctx context.Context, log mlog.Log, token string, audiences []string, accessToken bool) (map[string]any, error) {
+	hdr, claims, signed, sig, err := jwtParse(token)
+	if err != nil {
+		return nil, err
+	}
+	if isAccess := strings.EqualFold(hdr.Typ, "at+jwt") || strings.EqualFold(hdr.Typ, "application/at+jwt"); accessToken && !isAccess {
+		return nil, fmt.Errorf("%w: jwt type %q is not an access token, expected at+jwt", ErrToken, hdr.Typ)
+	} else if !accessToken && isAccess {
+		return nil, fmt.Errorf("%w: access token instead of id token", ErrToken)
+	}
+	key, err := p.key(ctx, log, hdr)
+	if err != nil {
+		return nil, err
+	}
+	if err := jwsVerify(hdr.Alg, key, signed, sig); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrToken, err)
+	}
+	if err := p.checkClaims(claims, audiences); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// checkClaims checks the registered claims for issuer, audience and validity
+// period, RFC 7519 section 4.1.
+func (p *Provider) checkClaims(claims map[string]any, audiences []string) error {
+	if iss, _ := claims["iss"].(string); iss != p.config.Issuer {
+		return fmt.Errorf("%w: issuer %q, expected %q", ErrToken, iss, p.config.Issuer)
+	}
+
+	var auds []string
+	switch v := claims["aud"].(type) {
+	case string:
+		auds = []string{v}
+	case []any:
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				auds = append(auds, s)
+			}
+		}
+	}
+	// Introspection responses may only have the client the token was issued to.
+	if cid, ok := claims["client_id"].(string); ok && claims["aud"] == nil {
+		auds = []string{cid}
+	}
+	if !slices.ContainsFunc(auds, func(s string) bool { return slices.Contains(audiences, s) }) {
+		return fmt.Errorf("%w: audience %v not accepted", ErrToken, auds)
+	}
+
+	now := time.Now()
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return fmt.Errorf("%w: missing expiration time", ErrToken)
+	}
+	if now.Add(-clockSkew).After(time.Unix(int64(exp), 0)) {
+		return fmt.Errorf("%w: token expired", ErrToken)
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(clockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return fmt.Errorf("%w: token not yet valid", ErrToken)
+	}
+	return nil
+}
+
+// key returns the public key for verifying a JWT with the header.
+func (p *Provider) key(ctx context.Context, log mlog.Log, hdr jwtHeader) (crypto.PublicKey, error) {
+	keys, err := p.jwks(ctx, log, false)
+	if err != nil {
+		return nil, err
+	}
+
+	find := func() (jwk, bool) {
+		if hdr.Kid != "" {
+			k, ok := keys[hdr.Kid]
+			return k, ok
+		}
+		// Without key ID, a single key must be unambiguous.
+		if len(keys) == 1 {
+			for _, k := range keys {
+				return k, true
+			}
+		}
+		return jwk{}, false
+	}
+	k, ok := find()
+	if !ok {
+		// Keys may have been rotated.
+		keys, err = p.jwks(ctx, log, true)
+		if err != nil {
+			return nil, err
+		}
+		k, ok = find()
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown key id %q", ErrToken, hdr.Kid)
+		}
+	}
+	if k.Alg != "" && k.Alg != hdr.Alg {
+		return nil, fmt.Errorf("%w: algorithm %q does not match key algorithm %q", ErrToken, hdr.Alg, k.Alg)
+	}
+	pk, err := k.publicKey()
+	if err != nil {
+		return nil, fmt.Errorf("%w: parsing key %q: %v", ErrToken, k.Kid, err)
+	}
+	return pk, nil
+}
+
+// metadata returns the configuration of the identity provider, fetching it when
+// not yet cached or too old.
+func (p *Provider) metadata(ctx context.Context, log mlog.Log) (*metadata, error) {
+	p.Lock()
+	defer p.Unlock()
+	return p.metadataLocked(ctx, log)
+}
+
+func (p *Provider) metadataLocked(ctx context.Context, log mlog.Log) (*metadata, error) {
+	if p.meta != nil && time.Since(p.metaTime) < metadataInterval {
+		return p.meta, nil
+	}
+	var meta metadata
+	if err := p.get(ctx, log, p.ConfigurationURL(), &meta); err != nil {
+		return nil, fmt.Errorf("fetching openid configuration: %w", err)
+	}
+	// OpenID Connect Discovery 1.0 section 4.3.
+	if meta.Issuer != p.config.Issuer {
+		return nil, fmt.Errorf("issuer %q in openid configuration does not match configured issuer %q", meta.Issuer, p.config.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, fmt.Errorf("openid configuration is missing endpoints")
+	}
+	p.meta = &meta
+	p.metaTime = time.Now()
+	return p.meta, nil
+}
+
+// jwks returns the keys of the identity provider, fetching them when not yet
+// cached, too old, or when refresh is set and they were not recently fetched.
+func (p *Provider) jwks(ctx context.Context, log mlog.Log, refresh bool) (map[string]jwk, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	age := time.Since(p.keysTime)
+	if p.keys != nil && age < keysInterval && (!refresh || age < keysMinInterval) {
+		return p.keys, nil
+	}
+	meta, err := p.metadataLocked(ctx, log)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := p.get(ctx, log, meta.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("fetching keys: %w", err)
+	}
+	keys := map[string]jwk{}
+	for _, k := range set.Keys {
+		if k.Use == "" || k.Use == "sig" {
+			keys[k.Kid] = k
+		}
+	}
+	p.keys = keys
+	p.keysTime = time.Now()
+	return keys, nil
+}
+
+// get fetches a JSON document.
+func (p *Provider) get(ctx context.Context, log mlog.Log, u string, result any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return fmt.Errorf("new request: %v", err)
+	}
+	return p.do(ctx, log, req, result)
+}
+
+// post sends a form with client authentication, and parses the JSON response.
+// Error responses with a JSON body, as used by the token endpoint, are returned
+// as result, for the caller to handle.
+func (p *Provider) post(ctx context.Context, log mlog.Log, u string, form url.Values, result any) error {
+	if p.config.ClientSecret == "" {
+		form.Set("client_id", p.config.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", u, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("new request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if p.config.ClientSecret != "" {
+		// RFC 6749 section 2.3.1.
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+	return p.do(ctx, log, req, result)
+}
+
+func (p *Provider) do(ctx context.Context, log mlog.Log, req *http.Request, result any) error {
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", fmt.Sprintf("mox/%s (oidc)", moxvar.Version))
+
+	start := time.Now()
+	resp, err := HTTPClient.Do(req)
+	var code int
+	if resp != nil {
+		code = resp.StatusCode
+	}
+	HTTPClientObserve(ctx, log.Logger, "oidc", req.Method, code, err, start)
+	if err != nil {
+		return fmt.Errorf("http request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	buf, err := io.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+	if err != nil {
+		return fmt.Errorf("reading response: %v", err)
+	}
+	isJSON := strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json")
+	if resp.StatusCode != http.StatusOK && !(req.Method == "POST" && resp.StatusCode == http.StatusBadRequest && isJSON) {
+		return fmt.Errorf("http response status %s", resp.Status)
+	}
+	if err := json.Unmarshal(buf, result); err != nil {
+		return fmt.Errorf("parsing json response: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/oidc/oidctest"
+)
+
+func tcheck(t *testing.T, err error, msg string) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %s", msg, err)
+	}
+}
+
+var b64 = base64.RawURLEncoding
+
+func TestOIDC(t *testing.T) {
+	idp := oidctest.NewIDP()
+	defer idp.Close()
+
+	log := mlog.New("oidc", nil)
+	ctx := context.Background()
+	conf := &config.OIDC{Issuer: idp.Server.URL, ClientID: "mox", ClientSecret: "secret", Audiences: []string{"imap"}}
+	p := Get(conf)
+	if Get(conf) != p || Get(nil) != nil {
+		t.Fatalf("provider not cached")
+	}
+
+	// Authorization code flow.
+	authURL, err := p.AuthURL(ctx, log, "https://mox.example/webmail/", "state1", "nonce1", "verifier1")
+	tcheck(t, err, "auth url")
+	u, err := url.Parse(authURL)
+	tcheck(t, err, "parse auth url")
+	q := u.Query()
+	if u.Path != "/auth" || q.Get("client_id") != "mox" || q.Get("state") != "state1" || q.Get("nonce") != "nonce1" || q.Get("code_challenge") != CodeChallenge("verifier1") || q.Get("code_challenge_method") != "S256" || !strings.Contains(q.Get("scope"), "openid") {
+		t.Fatalf("bad auth url %s", authURL)
+	}
+
+	code := func(nonce string, modify func(c map[string]any)) string {
+		c := idp.Claims("mox", "mjl@mox.example")
+		c["nonce"] = nonce
+		c["challenge"] = CodeChallenge("verifier1")
+		if modify != nil {
+			modify(c)
+		}
+		k := "code" + nonce
+		idp.Codes[k] = c
+		return k
+	}
+	xexchange := func(code string, expErr error) {
+		t.Helper()
+		email, err := p.Exchange(ctx, log, code, "https://mox.example/webmail/", "verifier1", "nonce1")
+		if expErr == nil {
+			tcheck(t, err, "exchange")
+			if email != "mjl@mox.example" {
+				t.Fatalf("got email %q", email)
+			}
+		} else if !errors.Is(err, expErr) {
+			t.Fatalf("exchange: got err %v, expected %v", err, expErr)
+		}
+	}
+	xexchange(code("nonce1", nil), nil)
+	xexchange("unknown", ErrToken)
+	xexchange(code("other", nil), ErrToken)
+	xexchange(code("nonce1", func(c map[string]any) { c["aud"] = "other" }), ErrToken)
+	xexchange(code("nonce1", func(c map[string]any) { c["aud"] = []string{"other", "mox"}; c["azp"] = "other" }), ErrToken)
+	xexchange(code("nonce1", func(c map[string]any) { c["exp"] = time.Now().Add(-time.Hour).Unix() }), ErrToken)
+	xexchange(code("nonce1", func(c map[string]any) { c["iss"] = "https://other.example" }), ErrToken)
+	xexchange(code("nonce1", func(c map[string]any) { c["email_verified"] = false }), ErrToken)
+	xexchange(code("nonce1", func(c map[string]any) { delete(c, "email") }), ErrToken)
+
+	// Access tokens as JWT.
+	xaccess := func(token string, expErr error) {
+		t.Helper()
+		email, err := p.VerifyAccessToken(ctx, log, token)
+		if expErr == nil {
+			tcheck(t, err, "verify access token")
+			if email != "mjl@mox.example" {
+				t.Fatalf("got email %q", email)
+			}
+		} else if !errors.Is(err, expErr) {
+			t.Fatalf("verify access token: got err %v, expected %v", err, expErr)
+		}
+	}
+	xaccess(idp.SignAccess(idp.Claims("imap", "mjl@mox.example")), nil)
+	xaccess(idp.SignAccess(idp.Claims("other", "mjl@mox.example")), ErrToken)
+	token := idp.SignAccess(idp.Claims("imap", "mjl@mox.example"))
+	xaccess(token[:len(token)-4]+"AAAA", ErrToken)
+	// ID tokens are not access tokens, not even with an accepted audience.
+	xaccess(idp.Sign(idp.Claims("imap", "mjl@mox.example")), ErrToken)
+	xaccess(idp.SignTyp("application/at+jwt", idp.Claims("imap", "mjl@mox.example")), nil)
+	// And access tokens are not ID tokens.
+	xexchange(code("nonce1", func(c map[string]any) { c["_typ"] = "at+jwt" }), ErrToken)
+
+	// Opaque tokens through introspection.
+	idp.Opaque["opaque1"] = map[string]any{"active": true, "iss": idp.Server.URL, "client_id": "mox", "aud": "imap", "exp": time.Now().Add(time.Hour).Unix(), "email": "mjl@mox.example"}
+	idp.Opaque["opaque2"] = map[string]any{"active": true, "iss": idp.Server.URL, "client_id": "other", "exp": time.Now().Add(time.Hour).Unix(), "email": "mjl@mox.example"}
+	xaccess("opaque1", nil)
+	xaccess("opaque2", ErrToken)
+	xaccess("unknown", ErrToken)
+
+	// Key rotation, to keys of other types. Unknown key IDs cause a refetch, but not
+	// too often.
+	for _, kt := range []string{"ec", "ed25519"} {
+		p.keysTime = time.Now().Add(-keysMinInterval)
+		switch kt {
+		case "ec":
+			key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			tcheck(t, err, "generate key")
+			idp.SetKey(key, "k2")
+		case "ed25519":
+			_, key, err := ed25519.GenerateKey(rand.Reader)
+			tcheck(t, err, "generate key")
+			idp.SetKey(key, "k3")
+		}
+		xaccess(idp.SignAccess(idp.Claims("imap", "mjl@mox.example")), nil)
+	}
+	n := idp.JWKSRequests
+	idp.SetKeyID("unknown")
+	xaccess(idp.SignAccess(idp.Claims("imap", "mjl@mox.example")), ErrToken)
+	xaccess(idp.SignAccess(idp.Claims("imap", "mjl@mox.example")), ErrToken)
+	if idp.JWKSRequests != n {
+		t.Fatalf("keys refetched too soon")
+	}
+
+	// Signatures with algorithm none are never accepted.
+	hdr := b64.EncodeToString([]byte(`{"alg":"none","kid":"k3"}`))
+	payload, _ := json.Marshal(idp.Claims("imap", "mjl@mox.example"))
+	xaccess(hdr+"."+b64.EncodeToString(payload)+".", ErrToken)
+
+	// Without configured audiences, access tokens are not accepted.
+	noaud := New(config.OIDC{Issuer: idp.Server.URL, ClientID: "mox", ClientSecret: "secret"})
+	if _, err := noaud.VerifyAccessToken(ctx, log, idp.SignAccess(idp.Claims("mox", "mjl@mox.example"))); !errors.Is(err, ErrToken) {
+		t.Fatalf("access token without configured audiences: got err %v, expected ErrToken", err)
+	}
+
+	// Issuer in configuration document must match.
+	bad := New(config.OIDC{Issuer: idp.Server.URL + "/other", ClientID: "mox"})
+	if _, err := bad.AuthURL(ctx, log, "https://mox.example/", "s", "n", "v"); err == nil {
+		t.Fatalf("auth url with mismatching issuer succeeded")
+	}
+}
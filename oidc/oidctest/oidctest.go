@@ -0,0 +1,188 @@
+// Package oidctest implements a fake OpenID Connect identity provider for tests.
+package oidctest
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+var b64 = base64.RawURLEncoding
+
+// ClientID is the client ID of mox at the identity provider.
+const ClientID = "mox"
+
+// IDP is an identity provider that issues tokens for a single client, with
+// ClientID and Secret. It serves the openid configuration, keys, a token
+// endpoint for the authorization code flow, and token introspection.
+type IDP struct {
+	Server       *httptest.Server
+	Codes        map[string]map[string]any // Code to ID token claims. Claim "challenge" must match the PKCE code verifier, optional claim "_typ" sets the token type.
+	Opaque       map[string]map[string]any // Opaque access token to introspection response.
+	Secret       string                    // Client secret.
+	JWKSRequests int                       // Number of requests for the keys.
+
+	signer crypto.Signer
+	alg    string
+	kid    string
+	jwk    map[string]string
+}
+
+// NewIDP starts a new identity provider, with an RSA key. Call Close when done.
+func NewIDP() *IDP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(fmt.Sprintf("generating key: %v", err))
+	}
+	idp := &IDP{
+		Codes:  map[string]map[string]any{},
+		Opaque: map[string]map[string]any{},
+		Secret: "secret",
+	}
+	idp.SetKey(key, "k1")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.Server.URL,
+			"authorization_endpoint": idp.Server.URL + "/auth",
+			"token_endpoint":         idp.Server.URL + "/token",
+			"jwks_uri":               idp.Server.URL + "/jwks",
+			"introspection_endpoint": idp.Server.URL + "/introspect",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		idp.JWKSRequests++
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{idp.jwk}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		user, pass, _ := r.BasicAuth()
+		claims, ok := idp.Codes[r.FormValue("code")]
+		h := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if user != ClientID || pass != idp.Secret || !ok || r.FormValue("code_verifier") == "" || claims["challenge"] != b64.EncodeToString(h[:]) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		delete(claims, "challenge")
+		typ := "JWT"
+		if t, ok := claims["_typ"].(string); ok {
+			typ = t
+			delete(claims, "_typ")
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idp.SignTyp(typ, claims), "access_token": "x", "token_type": "Bearer"})
+	})
+	mux.HandleFunc("/introspect", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if user, pass, _ := r.BasicAuth(); user != ClientID || pass != idp.Secret {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		resp, ok := idp.Opaque[r.FormValue("token")]
+		if !ok {
+			resp = map[string]any{"active": false}
+		}
+		json.NewEncoder(w).Encode(resp)
+	})
+	idp.Server = httptest.NewServer(mux)
+	return idp
+}
+
+// Close stops the server of the identity provider.
+func (idp *IDP) Close() {
+	idp.Server.Close()
+}
+
+// SetKey changes the signing key, e.g. for testing key rotation. Supported are
+// RSA, ECDSA P-256 and Ed25519 keys.
+func (idp *IDP) SetKey(signer crypto.Signer, kid string) {
+	idp.signer = signer
+	idp.kid = kid
+	switch k := signer.(type) {
+	case *rsa.PrivateKey:
+		idp.alg = "RS256"
+		idp.jwk = map[string]string{"kty": "RSA", "kid": kid, "n": b64.EncodeToString(k.N.Bytes()), "e": b64.EncodeToString(big.NewInt(int64(k.E)).Bytes())}
+	case *ecdsa.PrivateKey:
+		idp.alg = "ES256"
+		idp.jwk = map[string]string{"kty": "EC", "kid": kid, "crv": "P-256", "x": b64.EncodeToString(k.X.FillBytes(make([]byte, 32))), "y": b64.EncodeToString(k.Y.FillBytes(make([]byte, 32)))}
+	case ed25519.PrivateKey:
+		idp.alg = "EdDSA"
+		idp.jwk = map[string]string{"kty": "OKP", "kid": kid, "crv": "Ed25519", "x": b64.EncodeToString(k.Public().(ed25519.PublicKey))}
+	default:
+		panic(fmt.Sprintf("unsupported key type %T", signer))
+	}
+}
+
+// SetKeyID changes the key ID in the header of new tokens, without changing the
+// key.
+func (idp *IDP) SetKeyID(kid string) {
+	idp.kid = kid
+}
+
+// Claims returns claims for a token for email, with audience aud, valid for an
+// hour.
+func (idp *IDP) Claims(aud string, email string) map[string]any {
+	return map[string]any{
+		"iss":   idp.Server.URL,
+		"sub":   "1234",
+		"aud":   aud,
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"iat":   time.Now().Unix(),
+		"email": email,
+	}
+}
+
+// AccessToken returns a signed access token for email, with audience aud.
+func (idp *IDP) AccessToken(aud, email string) string {
+	return idp.SignAccess(idp.Claims(aud, email))
+}
+
+// Sign returns a JWT of type "JWT" with claims, like an ID token.
+func (idp *IDP) Sign(claims map[string]any) string {
+	return idp.SignTyp("JWT", claims)
+}
+
+// SignAccess returns a JWT of type "at+jwt" with claims, like an access token.
+func (idp *IDP) SignAccess(claims map[string]any) string {
+	return idp.SignTyp("at+jwt", claims)
+}
+
+// SignTyp returns a JWT of type typ with claims.
+func (idp *IDP) SignTyp(typ string, claims map[string]any) string {
+	hdr, _ := json.Marshal(map[string]string{"alg": idp.alg, "kid": idp.kid, "typ": typ})
+	payload, _ := json.Marshal(claims)
+	signed := b64.EncodeToString(hdr) + "." + b64.EncodeToString(payload)
+	var sig []byte
+	var err error
+	switch k := idp.signer.(type) {
+	case *rsa.PrivateKey:
+		h := sha256.Sum256([]byte(signed))
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, h[:])
+	case *ecdsa.PrivateKey:
+		h := sha256.Sum256([]byte(signed))
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k, h[:])
+		if err == nil {
+			sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		}
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, []byte(signed))
+	}
+	if err != nil {
+		panic(err)
+	}
+	return signed + "." + b64.EncodeToString(sig)
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	cryptorand "crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/smtpclient"
+)
+
+// Ports of services on the public listener of a typical mox setup, as configured
+// by quickstart.
+var preflightPorts = []struct {
+	Port    int
+	Service string
+}{
+	{25, "smtp"},
+	{465, "submissions"},
+	{587, "submission"},
+	{993, "imaps"},
+	{443, "https"},
+	{80, "http"},
+}
+
+// Known restrictions on outgoing smtp connections by hosting providers, matched
+// on the reverse DNS names of the public IPs. Policies change over time, the
+// hints only point in the right direction.
+var preflightProviders = []struct {
+	Suffix string
+	Hint   string
+}{
+	{".amazonaws.com.", "Amazon AWS restricts outgoing connections to port 25 by default. Request removal of the restriction through AWS support."},
+	{".googleusercontent.com.", "Google Cloud blocks outgoing connections to port 25. Configure a smarthost for outgoing email."},
+	{".your-server.de.", "Hetzner blocks outgoing connections to ports 25 and 465 for new cloud servers. Request unblocking through Hetzner support."},
+	{".vultrusercontent.com.", "Vultr blocks outgoing connections to port 25 for new accounts. Request unblocking through a support ticket."},
+	{".linodeusercontent.com.", "Linode restricts outgoing connections to port 25 for new accounts. Request unblocking through a support ticket."},
+}
+
+// preflightDialSMTP checks whether outgoing smtp connections can be made, by
+// connecting to port 25 of the first mx host of gmail.com.
+func preflightDialSMTP(ctx context.Context, resolver dns.Resolver, dialer smtpclient.Dialer) error {
+	mxctx, mxcancel := context.WithTimeout(ctx, 5*time.Second)
+	mx, _, err := resolver.LookupMX(mxctx, "gmail.com.")
+	mxcancel()
+	if err == nil && len(mx) == 0 {
+		err = errors.New("no mx records")
+	}
+	if err != nil {
+		return fmt.Errorf("looking up gmail.com mx record: %s", err)
+	}
+
+	dialctx, dialcancel := context.WithTimeout(ctx, 10*time.Second)
+	defer dialcancel()
+	addr := net.JoinHostPort(mx[0].Host, "25")
+	conn, err := dialer.DialContext(dialctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("connecting to %s: %s", addr, err)
+	}
+	conn.Close()
+	return nil
+}
+
+// preflightProviderHints returns hints about known restrictions by the hosting
+// provider, based on the reverse DNS names of the public IPs of this machine.
+func preflightProviderHints(ctx context.Context, resolver dns.Resolver) []string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	var ips []net.IP
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if ok && !ipnet.IP.IsLoopback() && !ipnet.IP.IsPrivate() && !ipnet.IP.IsLinkLocalUnicast() {
+			ips = append(ips, ipnet.IP)
+		}
+	}
+	return preflightIPHints(ctx, resolver, ips)
+}
+
+// preflightIPHints returns the hints of providers matching the reverse DNS names
+// of ips.
+func preflightIPHints(ctx context.Context, resolver dns.Resolver, ips []net.IP) []string {
+	var hints []string
+	seen := map[string]bool{}
+	for _, ip := range ips {
+		rctx, rcancel := context.WithTimeout(ctx, 5*time.Second)
+		names, _, err := resolver.LookupAddr(rctx, ip.String())
+		rcancel()
+		if err != nil {
+			continue
+		}
+		for _, name := range names {
+			for _, p := range preflightProviders {
+				if strings.HasSuffix(strings.ToLower(name), p.Suffix) && !seen[p.Hint] {
+					seen[p.Hint] = true
+					hints = append(hints, p.Hint)
+				}
+			}
+		}
+	}
+	return hints
+}
+
+func cmdPreflightListen(c *cmd) {
+	c.params = "[-skipdial] [-wait duration]"
+	c.help = `Check network connectivity of this machine before setting up mox.
+
+Checks whether outgoing smtp connections (port 25) can be made, and whether the
+ports of the public listener of mox (25, 465, 587, 993, 443 and 80) can be
+listened on. Ports that are in use, e.g. by an existing mail or web server, or
+that need root privileges, are reported.
+
+It then keeps listening on the ports, and prints a command with a random token
+to run on a machine outside your network, "mox preflight check". That command
+connects to each port, showing whether the ports are reachable from the
+internet, or blocked by a firewall, e.g. of your hosting provider. Connections
+are not handled beyond writing the token. Listening stops when all ports were
+reached, or after the wait duration.
+
+Many hosting providers block incoming or outgoing smtp connections by default.
+If the reverse DNS names of the public IPs of this machine match a known
+provider, a hint for that provider is printed.
+
+Run this command before starting mox, as root, so the ports can be listened on.
+`
+	var skipDial bool
+	wait := 10 * time.Minute
+	c.flag.BoolVar(&skipDial, "skipdial", false, "skip check for outgoing smtp (port 25) connectivity")
+	c.flag.DurationVar(&wait, "wait", wait, "how long to wait for connections from the remote check")
+	args := c.Parse()
+	if len(args) != 0 {
+		c.Usage()
+	}
+
+	resolver := dns.StrictResolver{}
+	ctx := context.Background()
+	hints := preflightProviderHints(ctx, resolver)
+	var problems bool
+
+	if !skipDial {
+		fmt.Printf("Checking if outgoing smtp connections can be made by connecting to gmail.com mx on port 25...")
+		if err := preflightDialSMTP(ctx, resolver, &net.Dialer{}); err != nil {
+			problems = true
+			fmt.Printf("\nERROR: %s\n", err)
+			fmt.Printf(`
+Outgoing smtp connections may be blocked by your provider. Many providers block
+outgoing smtp connections by default, requiring an explicit request or a
+cooldown period before allowing them. To send through a smarthost instead,
+configure a "Transport" in mox.conf, see "mox config example transport".
+`)
+			for _, h := range hints {
+				fmt.Printf("\nHINT: %s\n", h)
+			}
+			fmt.Println()
+		} else {
+			fmt.Println(" OK")
+		}
+	}
+
+	buf := make([]byte, 12)
+	cryptorand.Read(buf)
+	token := base64.RawURLEncoding.EncodeToString(buf)
+
+	fmt.Printf("Checking if ports can be listened on...")
+	type listener struct {
+		net.Listener
+		Port    int
+		Service string
+	}
+	var listeners []listener
+	var listenErrs []string
+	for _, p := range preflightPorts {
+		ln, err := net.Listen("tcp", fmt.Sprintf(":%d", p.Port))
+		if err != nil {
+			listenErrs = append(listenErrs, "ERROR: "+preflightListenError(p.Port, p.Service, err))
+			continue
+		}
+		listeners = append(listeners, listener{ln, p.Port, p.Service})
+	}
+	if len(listenErrs) > 0 {
+		problems = true
+		fmt.Printf("\n%s\n\n", strings.Join(listenErrs, "\n"))
+	} else {
+		fmt.Println(" OK")
+	}
+	if len(listeners) == 0 {
+		log.Fatalf("no ports to listen on")
+	}
+
+	var ports []string
+	for _, l := range listeners {
+		ports = append(ports, fmt.Sprintf("%d", l.Port))
+	}
+	fmt.Printf(`Listening on ports %s. On a machine outside your network, run:
+
+	mox preflight check -ports %s <public-ip-or-hostname> %s
+
+Waiting for connections...
+`, strings.Join(ports, ", "), strings.Join(ports, ","), token)
+
+	var mu sync.Mutex
+	reached := map[int]bool{}
+	done := make(chan struct{})
+	var doneOnce sync.Once
+	for _, l := range listeners {
+		go func(l listener) {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+				fmt.Fprintf(conn, "mox preflight %s\r\n", token)
+				conn.Close()
+
+				mu.Lock()
+				if !reached[l.Port] {
+					fmt.Printf("Port %d (%s): connection from %s\n", l.Port, l.Service, conn.RemoteAddr())
+				}
+				reached[l.Port] = true
+				if len(reached) == len(listeners) {
+					doneOnce.Do(func() { close(done) })
+				}
+				mu.Unlock()
+			}
+		}(l)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(wait):
+	}
+	for _, l := range listeners {
+		l.Close()
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	var missed []string
+	for _, l := range listeners {
+		if !reached[l.Port] {
+			missed = append(missed, fmt.Sprintf("%d (%s)", l.Port, l.Service))
+		}
+	}
+	if len(missed) > 0 {
+		problems = true
+		fmt.Printf(`
+WARNING: No connections received on ports %s. If "mox preflight check"
+reported timeouts, a firewall is likely dropping the connections: check the
+firewall of this machine (e.g. ufw, nftables or iptables), and the firewall
+rules or security groups at your hosting provider. Some providers block
+incoming smtp connections by default.
+`, strings.Join(missed, ", "))
+		for _, h := range hints {
+			fmt.Printf("\nHINT: %s\n", h)
+		}
+	} else {
+		fmt.Println("All ports reachable.")
+	}
+	if problems {
+		os.Exit(1)
+	}
+}
+
+// preflightListenError describes an error listening on a port, with a hint for
+// common causes.
+func preflightListenError(port int, service string, err error) string {
+	var hint string
+	if errors.Is(err, syscall.EADDRINUSE) {
+		hint = ", in use by another process, e.g. an existing mail or web server"
+	} else if errors.Is(err, syscall.EACCES) {
+		hint = ", permission denied, run as root"
+	}
+	return fmt.Sprintf("port %d (%s): %v%s", port, service, err, hint)
+}
+
+func cmdPreflightCheck(c *cmd) {
+	c.params = "[-ports ports] [-timeout duration] host token"
+	c.help = `Check whether the ports of a machine running "mox preflight listen" are reachable.
+
+Run this command on a machine outside the network of the machine you are
+setting up, with the host and token printed by "mox preflight listen". The
+command connects to each port and verifies the token. A connection that times
+out is likely dropped by a firewall. A refused connection means nothing is
+listening, or a firewall rejects the connection. A response without the token
+means another service answered, e.g. a proxy or port forward of your router.
+
+Many internet service providers block outgoing connections to port 25 for
+residential connections, so run this command from a machine that can make
+outgoing smtp connections.
+
+Exits with status 1 if a port could not be reached.
+`
+	ports := "25,465,587,993,443,80"
+	timeout := 10 * time.Second
+	c.flag.StringVar(&ports, "ports", ports, "comma-separated ports to check")
+	c.flag.DurationVar(&timeout, "timeout", timeout, "timeout for connecting and reading the token, per port")
+	args := c.Parse()
+	if len(args) != 2 {
+		c.Usage()
+	}
+	host, token := args[0], args[1]
+
+	var failed bool
+	for _, port := range strings.Split(ports, ",") {
+		port = strings.TrimSpace(port)
+		addr := net.JoinHostPort(host, port)
+		err := preflightCheckPort(addr, token, timeout)
+		if err != nil {
+			failed = true
+			fmt.Printf("port %s: error: %s\n", port, err)
+		} else {
+			fmt.Printf("port %s: ok\n", port)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// preflightCheckPort connects to addr and checks the response is the preflight
+// token.
+func preflightCheckPort(addr, token string, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		var neterr net.Error
+		if errors.As(err, &neterr) && neterr.Timeout() {
+			return fmt.Errorf("%v (likely dropped by firewall)", err)
+		} else if errors.Is(err, syscall.ECONNREFUSED) {
+			return fmt.Errorf("%v (nothing listening, or rejected by firewall)", err)
+		}
+		return err
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("reading token: %v", err)
+	}
+	if strings.TrimRight(line, "\r\n") != "mox preflight "+token {
+		return fmt.Errorf("unexpected response %q, not from mox preflight listen with this token", strings.TrimRight(line, "\r\n"))
+	}
+	return nil
+}
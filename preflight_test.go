@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/dns"
+)
+
+// preflightDialer records dialed addresses, and fails with err if set.
+type preflightDialer struct {
+	addrs []string
+	err   error
+}
+
+func (d *preflightDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	d.addrs = append(d.addrs, addr)
+	if d.err != nil {
+		return nil, d.err
+	}
+	c0, c1 := net.Pipe()
+	c1.Close()
+	return c0, nil
+}
+
+func TestPreflightDialSMTP(t *testing.T) {
+	test := func(resolver dns.Resolver, dialErr error, expAddrs []string, expErr string) {
+		t.Helper()
+		dialer := &preflightDialer{err: dialErr}
+		err := preflightDialSMTP(context.Background(), resolver, dialer)
+		if expErr == "" && err != nil || expErr != "" && (err == nil || !strings.Contains(err.Error(), expErr)) {
+			t.Fatalf("got err %v, expected %q", err, expErr)
+		}
+		if !reflect.DeepEqual(dialer.addrs, expAddrs) {
+			t.Fatalf("dialed %v, expected %v", dialer.addrs, expAddrs)
+		}
+	}
+
+	resolver := dns.MockResolver{
+		MX: map[string][]*net.MX{
+			"gmail.com.": {
+				{Host: "gmail-smtp-in.l.google.com.", Pref: 5},
+				{Host: "alt1.gmail-smtp-in.l.google.com.", Pref: 10},
+			},
+		},
+	}
+	test(resolver, nil, []string{"gmail-smtp-in.l.google.com.:25"}, "")
+	test(resolver, errors.New("connection timed out"), []string{"gmail-smtp-in.l.google.com.:25"}, "connecting to gmail-smtp-in.l.google.com.:25: connection timed out")
+
+	// MX lookup failures, no dial attempted.
+	test(dns.MockResolver{Fail: []string{"mx gmail.com."}}, nil, nil, "looking up gmail.com mx record")
+	test(dns.MockResolver{}, nil, nil, "looking up gmail.com mx record")
+	test(dns.MockResolver{MX: map[string][]*net.MX{"gmail.com.": {}}}, nil, nil, "no mx records")
+}
+
+func TestPreflightListenError(t *testing.T) {
+	test := func(err error, exp string) {
+		t.Helper()
+		s := preflightListenError(25, "smtp", err)
+		if !strings.HasPrefix(s, "port 25 (smtp): ") || !strings.HasSuffix(s, exp) {
+			t.Fatalf("got %q, expected suffix %q", s, exp)
+		}
+	}
+
+	opErr := func(errno syscall.Errno) error {
+		return &net.OpError{Op: "listen", Net: "tcp", Err: os.NewSyscallError("bind", errno)}
+	}
+	test(opErr(syscall.EADDRINUSE), ", in use by another process, e.g. an existing mail or web server")
+	test(opErr(syscall.EACCES), ", permission denied, run as root")
+	test(errors.New("other error"), ": other error")
+
+	// A real port in use.
+	if runtime.GOOS != "windows" {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		tcheck(t, err, "listen")
+		defer ln.Close()
+		_, err = net.Listen("tcp", ln.Addr().String())
+		if err == nil {
+			t.Fatalf("listening twice on the same port succeeded")
+		}
+		test(err, ", in use by another process, e.g. an existing mail or web server")
+	}
+}
+
+func TestPreflightIPHints(t *testing.T) {
+	awsHint := preflightProviders[0].Hint
+	hetznerHint := preflightProviders[2].Hint
+
+	resolver := dns.MockResolver{
+		PTR: map[string][]string{
+			"198.51.100.1": {"ec2-198-51-100-1.compute-1.amazonaws.com."},
+			"198.51.100.2": {"mail.example.", "static.2.100.51.198.clients.your-server.de."},
+			"198.51.100.3": {"EC2-198-51-100-3.COMPUTE-1.AMAZONAWS.COM."}, // Same hint, not repeated.
+			"198.51.100.4": {"mail.example."},
+			"198.51.100.5": {"amazonaws.com.example."}, // Suffix does not match.
+		},
+		Fail: []string{"ptr 198.51.100.6"},
+	}
+	ips := func(l ...string) []net.IP {
+		var r []net.IP
+		for _, s := range l {
+			r = append(r, net.ParseIP(s))
+		}
+		return r
+	}
+
+	test := func(ips []net.IP, exp []string) {
+		t.Helper()
+		hints := preflightIPHints(context.Background(), resolver, ips)
+		if !reflect.DeepEqual(hints, exp) {
+			t.Fatalf("got hints %v, expected %v", hints, exp)
+		}
+	}
+	test(nil, nil)
+	test(ips("198.51.100.4", "198.51.100.5", "198.51.100.6", "198.51.100.7"), nil)
+	test(ips("198.51.100.1"), []string{awsHint})
+	test(ips("198.51.100.6", "198.51.100.2", "198.51.100.1", "198.51.100.3"), []string{hetznerHint, awsHint})
+}
+
+func TestPreflightCheckPort(t *testing.T) {
+	serve := func(response string) string {
+		t.Helper()
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		tcheck(t, err, "listen")
+		t.Cleanup(func() { ln.Close() })
+		go func() {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			fmt.Fprint(conn, response)
+			conn.Close()
+		}()
+		return ln.Addr().String()
+	}
+
+	test := func(addr string, expErr string) {
+		t.Helper()
+		err := preflightCheckPort(addr, "token123", time.Second)
+		if expErr == "" && err != nil || expErr != "" && (err == nil || !strings.Contains(err.Error(), expErr)) {
+			t.Fatalf("got err %v, expected %q", err, expErr)
+		}
+	}
+
+	test(serve("mox preflight token123\r\n"), "")
+	test(serve("mox preflight other\r\n"), "not from mox preflight listen with this token")
+	test(serve("220 mail.example ESMTP\r\n"), `unexpected response "220 mail.example ESMTP"`)
+	test(serve(""), "reading token")
+
+	// Nothing listening.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	tcheck(t, err, "listen")
+	addr := ln.Addr().String()
+	ln.Close()
+	test(addr, "nothing listening, or rejected by firewall")
+}
@@ -0,0 +1,607 @@
+package pst
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/moxio"
+	"github.com/mjl-/mox/smtp"
+)
+
+// Well-known node ids, and node id types.
+const (
+	nidMessageStore    = 0x21
+	nidRootFolder      = 0x122
+	nidRecipientTable  = 0x692
+	nidTypeAttachment  = 0x05
+	nidTypeHierarchy   = 0x0d
+	nidTypeContents    = 0x0e
+	nidTypeMask        = 0x1f
+	maxEmbeddedMessage = 4
+)
+
+// Property ids.
+const (
+	propSubject                 = 0x0037
+	propClientSubmitTime        = 0x0039
+	propSentRepresentingName    = 0x0042
+	propSentRepresentingAddress = 0x0065
+	propTransportHeaders        = 0x007d
+	propRecipientType           = 0x0c15
+	propSenderName              = 0x0c1a
+	propSenderAddress           = 0x0c1f
+	propDeliveryTime            = 0x0e06
+	propMessageFlags            = 0x0e07
+	propBody                    = 0x1000
+	propHTML                    = 0x1013
+	propMessageID               = 0x1035
+	propInReplyTo               = 0x1042
+	propLastVerb                = 0x1081
+	propFlagStatus              = 0x1090
+	propDisplayName             = 0x3001
+	propAddressType             = 0x3002
+	propEmailAddress            = 0x3003
+	propCreationTime            = 0x3007
+	propContentCount            = 0x3602
+	propContainerClass          = 0x3613
+	propIPMSubtree              = 0x35e0
+	propAttachData              = 0x3701
+	propAttachFilename          = 0x3704
+	propAttachMethod            = 0x3705
+	propAttachLongFilename      = 0x3707
+	propAttachMimeTag           = 0x370e
+	propAttachContentID         = 0x3712
+	propSMTPAddress             = 0x39fe
+	propInternetCodepage        = 0x3fde
+	propSenderSMTPAddress       = 0x5d01
+	propLtpRowID                = 0x67f2
+)
+
+// Folder is a mail folder.
+type Folder struct {
+	NID   uint32
+	Path  []string // Names of the folder and its parent folders, starting below the top of the personal folders.
+	Count int      // Number of messages, as stored in the folder.
+}
+
+// Folders returns the mail folders, parent folders before their children.
+func (f *File) Folders() ([]Folder, error) {
+	root := uint32(nidRootFolder)
+	if n, err := f.node(nidMessageStore); err == nil {
+		if p, err := f.pc(n); err == nil {
+			// Entry id with flags, provider uid and node id.
+			if eid := p.binary(propIPMSubtree); len(eid) >= 24 {
+				root = le.Uint32(eid[20:])
+			}
+		}
+	}
+	var l []Folder
+	if err := f.walkFolders(root, nil, &l, 0); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (f *File) walkFolders(nid uint32, parent []string, l *[]Folder, depth int) error {
+	if depth > 64 {
+		return fmt.Errorf("%w: folders nested too deep", ErrFormat)
+	}
+	n, err := f.node(nid&^nidTypeMask | nidTypeHierarchy)
+	if errors.Is(err, errNotFound) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("hierarchy of folder %#x: %w", nid, err)
+	}
+	rows, err := f.tc(n)
+	if err != nil {
+		return fmt.Errorf("hierarchy of folder %#x: %w", nid, err)
+	}
+	for _, row := range rows {
+		v, _ := row.int(propLtpRowID)
+		cnid := uint32(v)
+		cn, err := f.node(cnid)
+		if err != nil {
+			return fmt.Errorf("folder %#x: %w", cnid, err)
+		}
+		p, err := f.pc(cn)
+		if err != nil {
+			return fmt.Errorf("folder %#x: %w", cnid, err)
+		}
+		// Skip folders for contacts, calendars, etc.
+		class := p.str(propContainerClass)
+		if class != "" && !strings.HasPrefix(class, "IPF.Note") && !strings.HasPrefix(class, "IPF.Imap") {
+			continue
+		}
+		count, _ := p.int(propContentCount)
+		path := append(slices.Clone(parent), p.str(propDisplayName))
+		*l = append(*l, Folder{cnid, path, int(count)})
+		if err := f.walkFolders(cnid, path, l, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Messages returns the node ids of the messages in a folder.
+func (f *File) Messages(folder Folder) ([]uint32, error) {
+	n, err := f.node(folder.NID&^nidTypeMask | nidTypeContents)
+	if errors.Is(err, errNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	rows, err := f.tc(n)
+	if err != nil {
+		return nil, err
+	}
+	l := make([]uint32, 0, len(rows))
+	for _, row := range rows {
+		if v, ok := row.int(propLtpRowID); ok {
+			l = append(l, uint32(v))
+		}
+	}
+	return l, nil
+}
+
+// Message is a message, with its flags and time of receipt.
+type Message struct {
+	Received  time.Time
+	Seen      bool
+	Answered  bool
+	Flagged   bool
+	Forwarded bool
+	Draft     bool
+
+	Data []byte // In MIME format.
+}
+
+// Message reads and converts the message with node id nid.
+func (f *File) Message(nid uint32) (*Message, error) {
+	n, err := f.node(nid)
+	if err != nil {
+		return nil, err
+	}
+	p, err := f.pc(n)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Message{}
+	flags, _ := p.int(propMessageFlags)
+	m.Seen = flags&0x01 != 0
+	m.Draft = flags&0x08 != 0
+	status, _ := p.int(propFlagStatus)
+	m.Flagged = status == 2
+	switch verb, _ := p.int(propLastVerb); verb {
+	case 102, 103: // Reply to sender, reply to all.
+		m.Answered = true
+	case 104:
+		m.Forwarded = true
+	}
+	m.Received = messageTime(p)
+
+	var b bytes.Buffer
+	if err := f.writeMessage(&b, n, p, 0); err != nil {
+		return nil, err
+	}
+	m.Data = b.Bytes()
+	return m, nil
+}
+
+func messageTime(p props) time.Time {
+	for _, id := range []uint16{propDeliveryTime, propClientSubmitTime, propCreationTime} {
+		if tm := p.time(id); !tm.IsZero() {
+			return tm
+		}
+	}
+	return time.Time{}
+}
+
+// attachment is an attached file, or an embedded message.
+type attachment struct {
+	filename  string
+	mediaType string
+	contentID string
+	data      []byte
+}
+
+// writeMessage writes message in node n with properties p in MIME format.
+func (f *File) writeMessage(w io.Writer, n *node, p props, depth int) (rerr error) {
+	c := message.NewComposer(w, 0, false)
+	defer func() {
+		x := recover()
+		if x == nil {
+			return
+		}
+		if err, ok := x.(error); ok && errors.Is(err, message.ErrCompose) {
+			rerr = err
+		} else {
+			panic(x)
+		}
+	}()
+
+	if headers := p.str(propTransportHeaders); headers != "" {
+		writeTransportHeaders(c, headers)
+	} else {
+		var recipients []props
+		if rn, err := f.subnode(n, nidRecipientTable); err == nil {
+			recipients, err = f.tc(rn)
+			if err != nil {
+				return fmt.Errorf("recipients: %w", err)
+			}
+		}
+		writeHeaders(c, p, recipients)
+	}
+	c.Header("MIME-Version", "1.0")
+
+	// Attachments are in subnodes, in order of their node id.
+	var attNIDs []uint32
+	for nid := range n.subnodes {
+		if nid&nidTypeMask == nidTypeAttachment {
+			attNIDs = append(attNIDs, nid)
+		}
+	}
+	sort.Slice(attNIDs, func(i, j int) bool {
+		return attNIDs[i] < attNIDs[j]
+	})
+	var attachments []attachment
+	for _, nid := range attNIDs {
+		a, err := f.attachment(n, nid, depth)
+		if err != nil {
+			return fmt.Errorf("attachment %#x: %w", nid, err)
+		}
+		if a != nil {
+			attachments = append(attachments, *a)
+		}
+	}
+
+	text := p.str(propBody)
+	var html []byte
+	htmlCharset := "utf-8"
+	if v, ok := p[propHTML]; ok {
+		switch v.typ {
+		case typeBinary:
+			html = bytes.TrimRight(v.data, "\x00")
+			if !utf8.Valid(html) {
+				cp, _ := p.int(propInternetCodepage)
+				htmlCharset = codepageCharset(cp)
+			}
+		case typeString, typeString8:
+			html = []byte(p.str(propHTML))
+		}
+	}
+
+	// Write the body parts, or the single part after the headers.
+	if len(attachments) == 0 {
+		writeBody(c, nil, text, html, htmlCharset)
+		c.Flush()
+		return nil
+	}
+
+	mp := multipart.NewWriter(c)
+	c.Header("Content-Type", fmt.Sprintf(`multipart/mixed; boundary="%s"`, mp.Boundary()))
+	c.Line()
+	writeBody(c, mp, text, html, htmlCharset)
+	for _, a := range attachments {
+		h := textproto.MIMEHeader{}
+		if a.mediaType == "message/rfc822" {
+			h.Set("Content-Type", "message/rfc822")
+			h.Set("Content-Disposition", "attachment")
+			pw, err := mp.CreatePart(h)
+			c.Checkf(err, "adding part")
+			_, err = pw.Write(a.data)
+			c.Checkf(err, "writing embedded message")
+			continue
+		}
+		params := map[string]string{}
+		if a.filename != "" {
+			params["name"] = a.filename
+		}
+		ct := mime.FormatMediaType(a.mediaType, params)
+		if ct == "" {
+			ct = a.mediaType
+		}
+		h.Set("Content-Type", ct)
+		disposition := "attachment"
+		if a.contentID != "" {
+			h.Set("Content-Id", "<"+strings.Trim(a.contentID, "<>")+">")
+			disposition = "inline"
+		}
+		if a.filename != "" {
+			if cd := mime.FormatMediaType(disposition, map[string]string{"filename": a.filename}); cd != "" {
+				disposition = cd
+			}
+		}
+		h.Set("Content-Disposition", disposition)
+		h.Set("Content-Transfer-Encoding", "base64")
+		pw, err := mp.CreatePart(h)
+		c.Checkf(err, "adding part")
+		bw := moxio.Base64Writer(pw)
+		_, err = bw.Write(a.data)
+		c.Checkf(err, "writing attachment")
+		err = bw.Close()
+		c.Checkf(err, "writing attachment")
+	}
+	err := mp.Close()
+	c.Checkf(err, "closing multipart")
+	c.Flush()
+	return nil
+}
+
+// writeBody writes the text and/or html body, as a part of mp if not nil, or
+// otherwise directly after the message headers.
+func writeBody(c *message.Composer, mp *multipart.Writer, text string, html []byte, htmlCharset string) {
+	textPart := func() (textproto.MIMEHeader, []byte) {
+		body, ct, cte := c.TextPart("plain", text)
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Type", ct)
+		h.Set("Content-Transfer-Encoding", cte)
+		return h, body
+	}
+	htmlPart := func() (textproto.MIMEHeader, []byte) {
+		var b bytes.Buffer
+		qpw := quotedprintable.NewWriter(&b)
+		_, err := qpw.Write(html)
+		c.Checkf(err, "encoding html")
+		err = qpw.Close()
+		c.Checkf(err, "encoding html")
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Type", mime.FormatMediaType("text/html", map[string]string{"charset": htmlCharset}))
+		h.Set("Content-Transfer-Encoding", "quoted-printable")
+		return h, b.Bytes()
+	}
+
+	var parts []func() (textproto.MIMEHeader, []byte)
+	if text != "" || len(html) == 0 {
+		parts = append(parts, textPart)
+	}
+	if len(html) > 0 {
+		parts = append(parts, htmlPart)
+	}
+
+	writePart := func(mp *multipart.Writer, part func() (textproto.MIMEHeader, []byte)) {
+		h, body := part()
+		pw, err := mp.CreatePart(h)
+		c.Checkf(err, "adding part")
+		_, err = pw.Write(body)
+		c.Checkf(err, "writing part")
+	}
+
+	if len(parts) == 1 && mp == nil {
+		h, body := parts[0]()
+		for _, k := range []string{"Content-Type", "Content-Transfer-Encoding"} {
+			c.Header(k, h.Get(k))
+		}
+		c.Line()
+		_, _ = c.Write(body)
+		return
+	} else if len(parts) == 1 {
+		writePart(mp, parts[0])
+		return
+	}
+
+	// Multipart/alternative, with its own boundary.
+	altBoundary := multipart.NewWriter(io.Discard).Boundary()
+	ct := fmt.Sprintf(`multipart/alternative; boundary="%s"`, altBoundary)
+	var w io.Writer = c
+	if mp == nil {
+		c.Header("Content-Type", ct)
+		c.Line()
+	} else {
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Type", ct)
+		pw, err := mp.CreatePart(h)
+		c.Checkf(err, "adding part")
+		w = pw
+	}
+	alt := multipart.NewWriter(w)
+	err := alt.SetBoundary(altBoundary)
+	c.Checkf(err, "setting boundary")
+	for _, part := range parts {
+		writePart(alt, part)
+	}
+	err = alt.Close()
+	c.Checkf(err, "closing multipart")
+}
+
+// writeTransportHeaders writes the headers as received by the mail server, except
+// headers about the MIME structure, which is recreated.
+func writeTransportHeaders(c *message.Composer, headers string) {
+	var skip bool
+	r := bufio.NewScanner(strings.NewReader(headers))
+	r.Buffer(nil, 1024*1024)
+	for r.Scan() {
+		line := strings.TrimRight(r.Text(), "\r")
+		if line == "" {
+			// Stored headers can end with an empty line.
+			break
+		}
+		if line[0] != ' ' && line[0] != '\t' {
+			k, _, _ := strings.Cut(line, ":")
+			switch strings.ToLower(strings.TrimSpace(k)) {
+			case "content-type", "content-transfer-encoding", "content-disposition", "mime-version":
+				skip = true
+			default:
+				skip = false
+			}
+		}
+		if !skip {
+			fmt.Fprintf(c, "%s\r\n", line)
+		}
+	}
+}
+
+// writeHeaders writes headers for a message without transport headers, from
+// its properties.
+func writeHeaders(c *message.Composer, p props, recipients []props) {
+	if tm := p.time(propClientSubmitTime); !tm.IsZero() {
+		c.Header("Date", tm.Format(message.RFC5322Z))
+	} else if tm := messageTime(p); !tm.IsZero() {
+		c.Header("Date", tm.Format(message.RFC5322Z))
+	}
+
+	nameAddress := func(name, addr string) (message.NameAddress, bool) {
+		a, err := smtp.ParseAddress(addr)
+		if err != nil {
+			return message.NameAddress{}, false
+		}
+		if name == addr {
+			name = ""
+		}
+		return message.NameAddress{DisplayName: name, Address: a}, true
+	}
+	if from, ok := nameAddress(p.str(propSentRepresentingName), p.str(propSentRepresentingAddress)); ok {
+		c.HeaderAddrs("From", []message.NameAddress{from})
+	} else if from, ok := nameAddress(p.str(propSenderName), p.str(propSenderSMTPAddress)); ok {
+		c.HeaderAddrs("From", []message.NameAddress{from})
+	} else if from, ok := nameAddress(p.str(propSenderName), p.str(propSenderAddress)); ok {
+		c.HeaderAddrs("From", []message.NameAddress{from})
+	}
+
+	var to, cc, bcc []message.NameAddress
+	for _, r := range recipients {
+		addr := r.str(propSMTPAddress)
+		if addr == "" && strings.EqualFold(r.str(propAddressType), "SMTP") {
+			addr = r.str(propEmailAddress)
+		}
+		na, ok := nameAddress(r.str(propDisplayName), addr)
+		if !ok {
+			continue
+		}
+		switch typ, _ := r.int(propRecipientType); typ {
+		case 1:
+			to = append(to, na)
+		case 2:
+			cc = append(cc, na)
+		case 3:
+			bcc = append(bcc, na)
+		}
+	}
+	c.HeaderAddrs("To", to)
+	c.HeaderAddrs("Cc", cc)
+	c.HeaderAddrs("Bcc", bcc)
+
+	subject := p.str(propSubject)
+	// A subject can start with 0x01 and the length of its prefix, like "Re: ".
+	if strings.HasPrefix(subject, "\x01") && len(subject) >= 2 {
+		subject = subject[2:]
+	}
+	if subject != "" {
+		c.Subject(subject)
+	}
+	if id := p.str(propMessageID); id != "" {
+		c.Header("Message-Id", id)
+	}
+	if id := p.str(propInReplyTo); id != "" {
+		c.Header("In-Reply-To", id)
+	}
+}
+
+// attachment reads an attachment of message node n. It returns nil for
+// attachments that cannot be represented, e.g. OLE objects.
+func (f *File) attachment(n *node, nid uint32, depth int) (*attachment, error) {
+	an, err := f.subnode(n, nid)
+	if err != nil {
+		return nil, err
+	}
+	p, err := f.pc(an)
+	if err != nil {
+		return nil, err
+	}
+	a := &attachment{
+		filename:  p.str(propAttachLongFilename),
+		mediaType: strings.ToLower(p.str(propAttachMimeTag)),
+		contentID: p.str(propAttachContentID),
+	}
+	if a.filename == "" {
+		a.filename = p.str(propAttachFilename)
+	}
+	if a.filename == "" {
+		a.filename = p.str(propDisplayName)
+	}
+
+	switch method, _ := p.int(propAttachMethod); method {
+	case 1: // By value.
+		a.data = p.binary(propAttachData)
+		if a.mediaType == "" {
+			a.mediaType = mime.TypeByExtension(strings.ToLower(path.Ext(a.filename)))
+		}
+		if a.mediaType == "" || strings.Contains(a.mediaType, ";") {
+			a.mediaType = "application/octet-stream"
+		}
+		return a, nil
+	case 5: // Embedded message.
+		if depth >= maxEmbeddedMessage {
+			return nil, nil
+		}
+		v, ok := p[propAttachData]
+		if !ok || v.typ != typeObject || len(v.data) < 4 {
+			return nil, nil
+		}
+		// Node id of subnode with message, and size.
+		mn, err := f.subnode(an, le.Uint32(v.data))
+		if err != nil {
+			return nil, err
+		}
+		mp, err := f.pc(mn)
+		if err != nil {
+			return nil, err
+		}
+		var b bytes.Buffer
+		if err := f.writeMessage(&b, mn, mp, depth+1); err != nil {
+			return nil, err
+		}
+		a.mediaType = "message/rfc822"
+		a.data = b.Bytes()
+		return a, nil
+	}
+	return nil, nil
+}
+
+// codepageCharset returns the MIME charset for a Windows code page, for the
+// most common code pages. The default is windows-1252.
+func codepageCharset(cp int64) string {
+	switch cp {
+	case 65001:
+		return "utf-8"
+	case 20127:
+		return "us-ascii"
+	case 28591:
+		return "iso-8859-1"
+	case 28592:
+		return "iso-8859-2"
+	case 28605:
+		return "iso-8859-15"
+	case 1250, 1251, 1253, 1254, 1255, 1256, 1257, 1258:
+		return fmt.Sprintf("windows-%d", cp)
+	case 20866:
+		return "koi8-r"
+	case 932:
+		return "shift_jis"
+	case 936:
+		return "gb2312"
+	case 949:
+		return "euc-kr"
+	case 950:
+		return "big5"
+	case 50220:
+		return "iso-2022-jp"
+	case 51932:
+		return "euc-jp"
+	}
+	return "windows-1252"
+}
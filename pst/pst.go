@@ -0,0 +1,686 @@
+// Package pst reads folders and messages from Outlook personal storage table
+// (.pst) files, for importing into mox.
+//
+// Both the ANSI format (Outlook 97-2002) and the Unicode format (Outlook 2003
+// and later) are supported, without encryption or with "compressible
+// encryption", the default. Files with "high encryption" are not supported.
+//
+// Only mail folders are read, e.g. contacts and calendar folders are skipped.
+// Messages are converted to MIME: Internet headers are taken from the transport
+// headers stored with received messages, or created from the message properties
+// for messages without them, such as sent messages. The body consists of the
+// plain text and HTML versions of the message, and attachments.
+//
+// The format is specified in [MS-PST].
+package pst
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+var (
+	ErrFormat      = errors.New("invalid pst file")
+	ErrUnsupported = errors.New("unsupported pst file")
+	errNotFound    = errors.New("node not found")
+)
+
+// File is an opened PST file.
+type File struct {
+	r       io.ReaderAt
+	unicode bool // Otherwise ANSI, with 32-bit block IDs and offsets.
+	permute bool // Whether external blocks are encoded with "compressible encryption".
+	nbt     map[uint32]nbtEntry
+	bbt     map[uint64]bbtEntry
+}
+
+// nbtEntry is a node in the node btree.
+type nbtEntry struct {
+	bidData uint64
+	bidSub  uint64
+}
+
+// bbtEntry is a block in the block btree.
+type bbtEntry struct {
+	ib uint64 // File offset.
+	cb uint16 // Size of data, without trailer.
+}
+
+var le = binary.LittleEndian
+
+// Open reads the header and index of a PST file.
+func Open(r io.ReaderAt) (*File, error) {
+	hdr := make([]byte, 564)
+	if _, err := r.ReadAt(hdr, 0); err != nil {
+		return nil, fmt.Errorf("%w: reading header: %v", ErrFormat, err)
+	}
+	if string(hdr[:4]) != "!BDN" {
+		return nil, fmt.Errorf("%w: bad magic", ErrFormat)
+	}
+	if client := string(hdr[8:10]); client != "SM" && client != "SO" {
+		return nil, fmt.Errorf("%w: bad client magic", ErrFormat)
+	}
+
+	f := &File{
+		r:   r,
+		nbt: map[uint32]nbtEntry{},
+		bbt: map[uint64]bbtEntry{},
+	}
+	var nbtRoot, bbtRoot uint64
+	var crypt byte
+	switch ver := le.Uint16(hdr[10:]); ver {
+	case 14, 15:
+		nbtRoot = uint64(le.Uint32(hdr[188:]))
+		bbtRoot = uint64(le.Uint32(hdr[196:]))
+		crypt = hdr[461]
+	case 23:
+		f.unicode = true
+		nbtRoot = le.Uint64(hdr[224:])
+		bbtRoot = le.Uint64(hdr[240:])
+		crypt = hdr[513]
+	default:
+		return nil, fmt.Errorf("%w: version %d", ErrUnsupported, ver)
+	}
+	switch crypt {
+	case 0:
+	case 1:
+		f.permute = true
+	default:
+		return nil, fmt.Errorf("%w: encryption method %d", ErrUnsupported, crypt)
+	}
+
+	if err := f.readBTree(bbtRoot, 0x80, 0); err != nil {
+		return nil, fmt.Errorf("reading block btree: %w", err)
+	}
+	if err := f.readBTree(nbtRoot, 0x81, 0); err != nil {
+		return nil, fmt.Errorf("reading node btree: %w", err)
+	}
+	return f, nil
+}
+
+// readBTree reads the btree page at offset ib, and its child pages, into the
+// node or block index.
+func (f *File) readBTree(ib uint64, ptype byte, depth int) error {
+	if depth > 16 {
+		return fmt.Errorf("%w: btree too deep", ErrFormat)
+	}
+	page := make([]byte, 512)
+	if _, err := f.r.ReadAt(page, int64(ib)); err != nil {
+		return fmt.Errorf("%w: reading btree page at %d: %v", ErrFormat, ib, err)
+	}
+
+	var nent, entSize, level, area int
+	var pageType byte
+	if f.unicode {
+		nent, entSize, level, pageType, area = int(page[488]), int(page[490]), int(page[491]), page[496], 488
+	} else {
+		nent, entSize, level, pageType, area = int(page[496]), int(page[498]), int(page[499]), page[500], 496
+	}
+	if pageType != ptype {
+		return fmt.Errorf("%w: btree page at %d has type %#x, expected %#x", ErrFormat, ib, pageType, ptype)
+	}
+	minSize := map[bool]int{true: 12, false: 16}[ptype == 0x80 || level > 0]
+	if f.unicode {
+		minSize *= 2
+	}
+	if entSize < minSize || nent*entSize > area {
+		return fmt.Errorf("%w: btree page at %d has bad entries", ErrFormat, ib)
+	}
+
+	for i := 0; i < nent; i++ {
+		e := page[i*entSize : (i+1)*entSize]
+		if level > 0 {
+			// Key, followed by block reference with block id and offset of child page.
+			var child uint64
+			if f.unicode {
+				child = le.Uint64(e[16:])
+			} else {
+				child = uint64(le.Uint32(e[8:]))
+			}
+			if err := f.readBTree(child, ptype, depth+1); err != nil {
+				return err
+			}
+		} else if ptype == 0x80 {
+			if f.unicode {
+				f.bbt[le.Uint64(e)&^1] = bbtEntry{le.Uint64(e[8:]), le.Uint16(e[16:])}
+			} else {
+				f.bbt[uint64(le.Uint32(e)&^1)] = bbtEntry{uint64(le.Uint32(e[4:])), le.Uint16(e[8:])}
+			}
+		} else {
+			if f.unicode {
+				f.nbt[le.Uint32(e)] = nbtEntry{le.Uint64(e[8:]), le.Uint64(e[16:])}
+			} else {
+				f.nbt[le.Uint32(e)] = nbtEntry{uint64(le.Uint32(e[4:])), uint64(le.Uint32(e[8:]))}
+			}
+		}
+	}
+	return nil
+}
+
+// bid reads a block id from buf.
+func (f *File) bid(buf []byte) uint64 {
+	if f.unicode {
+		return le.Uint64(buf)
+	}
+	return uint64(le.Uint32(buf))
+}
+
+func (f *File) bidSize() int {
+	if f.unicode {
+		return 8
+	}
+	return 4
+}
+
+// block returns the data of a block. Blocks with external data, i.e. not with
+// block references, are decoded.
+func (f *File) block(bid uint64) ([]byte, error) {
+	e, ok := f.bbt[bid&^1]
+	if !ok {
+		return nil, fmt.Errorf("%w: block %#x not found", ErrFormat, bid)
+	}
+	buf := make([]byte, e.cb)
+	if _, err := f.r.ReadAt(buf, int64(e.ib)); err != nil {
+		return nil, fmt.Errorf("%w: reading block %#x: %v", ErrFormat, bid, err)
+	}
+	if bid&2 == 0 && f.permute {
+		for i, c := range buf {
+			buf[i] = permuteDecode[c]
+		}
+	}
+	return buf, nil
+}
+
+// dataBlocks returns the data blocks for a data tree, following the references
+// in internal blocks.
+func (f *File) dataBlocks(bid uint64, depth int) ([][]byte, error) {
+	if bid == 0 {
+		return nil, nil
+	}
+	buf, err := f.block(bid)
+	if err != nil {
+		return nil, err
+	}
+	if bid&2 == 0 {
+		return [][]byte{buf}, nil
+	}
+	if depth > 1 || len(buf) < 8 || buf[0] != 0x01 {
+		return nil, fmt.Errorf("%w: bad data tree block %#x", ErrFormat, bid)
+	}
+	n := int(le.Uint16(buf[2:]))
+	size := f.bidSize()
+	if 8+n*size > len(buf) {
+		return nil, fmt.Errorf("%w: bad data tree block %#x", ErrFormat, bid)
+	}
+	var l [][]byte
+	for i := 0; i < n; i++ {
+		sub, err := f.dataBlocks(f.bid(buf[8+i*size:]), depth+1)
+		if err != nil {
+			return nil, err
+		}
+		l = append(l, sub...)
+	}
+	return l, nil
+}
+
+// subnode is an entry in the subnode tree of a node.
+type subnode struct {
+	bidData uint64
+	bidSub  uint64
+}
+
+func (f *File) readSubnodes(bid uint64, m map[uint32]subnode, depth int) error {
+	buf, err := f.block(bid)
+	if err != nil {
+		return err
+	}
+	if depth > 1 || len(buf) < 4 || buf[0] != 0x02 {
+		return fmt.Errorf("%w: bad subnode block %#x", ErrFormat, bid)
+	}
+	level := buf[1]
+	n := int(le.Uint16(buf[2:]))
+	size := f.bidSize()
+	off := 4
+	if f.unicode {
+		off = 8 // Padding.
+	}
+	entSize := 3 * size
+	if level > 0 {
+		entSize = 2 * size
+	}
+	if off+n*entSize > len(buf) {
+		return fmt.Errorf("%w: bad subnode block %#x", ErrFormat, bid)
+	}
+	for i := 0; i < n; i++ {
+		e := buf[off+i*entSize:]
+		nid := le.Uint32(e)
+		if level == 0 {
+			m[nid] = subnode{f.bid(e[size:]), f.bid(e[2*size:])}
+		} else if err := f.readSubnodes(f.bid(e[size:]), m, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// node is a node with its data blocks and subnodes.
+type node struct {
+	blocks   [][]byte
+	subnodes map[uint32]subnode
+}
+
+// data returns the data of the node as a single buffer.
+func (n *node) data() []byte {
+	return bytes.Join(n.blocks, nil)
+}
+
+func (f *File) makeNode(bidData, bidSub uint64) (*node, error) {
+	blocks, err := f.dataBlocks(bidData, 0)
+	if err != nil {
+		return nil, err
+	}
+	n := &node{blocks, map[uint32]subnode{}}
+	if bidSub != 0 {
+		if err := f.readSubnodes(bidSub, n.subnodes, 0); err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+// node returns a node from the node btree.
+func (f *File) node(nid uint32) (*node, error) {
+	e, ok := f.nbt[nid]
+	if !ok {
+		return nil, fmt.Errorf("%w: %#x", errNotFound, nid)
+	}
+	return f.makeNode(e.bidData, e.bidSub)
+}
+
+// subnode returns a subnode of n.
+func (f *File) subnode(n *node, nid uint32) (*node, error) {
+	s, ok := n.subnodes[nid]
+	if !ok {
+		return nil, fmt.Errorf("%w: subnode %#x", errNotFound, nid)
+	}
+	return f.makeNode(s.bidData, s.bidSub)
+}
+
+// heap is a heap-on-node, with items in the data blocks of a node.
+type heap struct {
+	f         *File
+	n         *node
+	clientSig byte
+	userRoot  uint32
+}
+
+func (f *File) heap(n *node) (*heap, error) {
+	if len(n.blocks) == 0 || len(n.blocks[0]) < 12 || n.blocks[0][2] != 0xec {
+		return nil, fmt.Errorf("%w: bad heap", ErrFormat)
+	}
+	b := n.blocks[0]
+	return &heap{f, n, b[3], le.Uint32(b[4:])}, nil
+}
+
+// item returns an item allocated on the heap.
+func (h *heap) item(hid uint32) ([]byte, error) {
+	if hid&0x1f != 0 {
+		return nil, fmt.Errorf("%w: bad heap id %#x", ErrFormat, hid)
+	}
+	index := int(hid>>5) & 0x7ff
+	blockIndex := int(hid >> 16)
+	if index == 0 {
+		return nil, nil
+	}
+	if blockIndex >= len(h.n.blocks) || len(h.n.blocks[blockIndex]) < 2 {
+		return nil, fmt.Errorf("%w: bad heap id %#x", ErrFormat, hid)
+	}
+	b := h.n.blocks[blockIndex]
+	pm := int(le.Uint16(b))
+	if pm+4 > len(b) {
+		return nil, fmt.Errorf("%w: bad heap page map", ErrFormat)
+	}
+	nalloc := int(le.Uint16(b[pm:]))
+	if index > nalloc || pm+4+(nalloc+1)*2 > len(b) {
+		return nil, fmt.Errorf("%w: bad heap id %#x", ErrFormat, hid)
+	}
+	start := int(le.Uint16(b[pm+4+(index-1)*2:]))
+	end := int(le.Uint16(b[pm+4+index*2:]))
+	if start > end || end > len(b) {
+		return nil, fmt.Errorf("%w: bad heap allocation", ErrFormat)
+	}
+	return b[start:end], nil
+}
+
+// hnid returns the data for a heap id or for a subnode.
+func (h *heap) hnid(hnid uint32) ([]byte, error) {
+	if hnid == 0 {
+		return nil, nil
+	}
+	if hnid&0x1f == 0 {
+		return h.item(hnid)
+	}
+	n, err := h.f.subnode(h.n, hnid)
+	if err != nil {
+		return nil, err
+	}
+	return n.data(), nil
+}
+
+// bth returns the leaf records of a btree-on-heap.
+func (h *heap) bth(hid uint32) (keySize, dataSize int, records [][]byte, rerr error) {
+	hdr, err := h.item(hid)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	if len(hdr) < 8 || hdr[0] != 0xb5 {
+		return 0, 0, nil, fmt.Errorf("%w: bad btree-on-heap header", ErrFormat)
+	}
+	keySize, dataSize = int(hdr[1]), int(hdr[2])
+	levels := int(hdr[3])
+	root := le.Uint32(hdr[4:])
+	if root == 0 {
+		return keySize, dataSize, nil, nil
+	}
+	records, err = h.bthLevel(root, keySize, dataSize, levels)
+	return keySize, dataSize, records, err
+}
+
+func (h *heap) bthLevel(hid uint32, keySize, dataSize, level int) ([][]byte, error) {
+	if level > 8 {
+		return nil, fmt.Errorf("%w: btree-on-heap too deep", ErrFormat)
+	}
+	b, err := h.item(hid)
+	if err != nil {
+		return nil, err
+	}
+	size := keySize + dataSize
+	if level > 0 {
+		size = keySize + 4
+	}
+	var l [][]byte
+	for off := 0; size > 0 && off+size <= len(b); off += size {
+		r := b[off : off+size]
+		if level == 0 {
+			l = append(l, r)
+			continue
+		}
+		sub, err := h.bthLevel(le.Uint32(r[keySize:]), keySize, dataSize, level-1)
+		if err != nil {
+			return nil, err
+		}
+		l = append(l, sub...)
+	}
+	return l, nil
+}
+
+// Property types.
+const (
+	typeInt16    = 0x0002
+	typeInt32    = 0x0003
+	typeFloat32  = 0x0004
+	typeFloat64  = 0x0005
+	typeCurrency = 0x0006
+	typeAppTime  = 0x0007
+	typeError    = 0x000a
+	typeBool     = 0x000b
+	typeObject   = 0x000d
+	typeInt64    = 0x0014
+	typeString8  = 0x001e
+	typeString   = 0x001f
+	typeTime     = 0x0040
+	typeBinary   = 0x0102
+)
+
+// prop is a property value. For fixed-size types, data holds the value. For other
+// types, it holds the referenced data.
+type prop struct {
+	typ  uint16
+	data []byte
+}
+
+// props are properties by id, of a property context or table row.
+type props map[uint16]prop
+
+// pc reads the property context stored in node n.
+func (f *File) pc(n *node) (props, error) {
+	h, err := f.heap(n)
+	if err != nil {
+		return nil, err
+	}
+	if h.clientSig != 0xbc {
+		return nil, fmt.Errorf("%w: not a property context", ErrFormat)
+	}
+	keySize, dataSize, records, err := h.bth(h.userRoot)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) > 0 && (keySize != 2 || dataSize != 6) {
+		return nil, fmt.Errorf("%w: bad property context", ErrFormat)
+	}
+	p := props{}
+	for _, r := range records {
+		id := le.Uint16(r)
+		typ := le.Uint16(r[2:])
+		switch typ {
+		case typeInt16, typeInt32, typeFloat32, typeError, typeBool:
+			p[id] = prop{typ, r[4:8]}
+		default:
+			if typ&0x1000 != 0 {
+				// Multiple values, not used.
+				continue
+			}
+			hnid := le.Uint32(r[4:])
+			if typ == typeObject && hnid&0x1f != 0 {
+				// Reference to the subnode with the object, instead of to a heap item with the
+				// subnode id and size.
+				p[id] = prop{typ, r[4:8]}
+				continue
+			}
+			data, err := h.hnid(hnid)
+			if err != nil {
+				return nil, fmt.Errorf("property %#04x: %w", id, err)
+			}
+			p[id] = prop{typ, data}
+		}
+	}
+	return p, nil
+}
+
+// tc reads the rows of the table context stored in node n.
+func (f *File) tc(n *node) ([]props, error) {
+	h, err := f.heap(n)
+	if err != nil {
+		return nil, err
+	}
+	if h.clientSig != 0x7c {
+		return nil, fmt.Errorf("%w: not a table context", ErrFormat)
+	}
+	info, err := h.item(h.userRoot)
+	if err != nil {
+		return nil, err
+	}
+	if len(info) < 22 || info[0] != 0x7c {
+		return nil, fmt.Errorf("%w: bad table context", ErrFormat)
+	}
+	ncols := int(info[1])
+	cebOffset := int(le.Uint16(info[6:]))
+	rowSize := int(le.Uint16(info[8:]))
+	hnidRows := le.Uint32(info[14:])
+	if len(info) < 22+ncols*8 {
+		return nil, fmt.Errorf("%w: bad table context columns", ErrFormat)
+	}
+	type column struct {
+		id, typ uint16
+		offset  int
+		size    int
+		bit     int
+	}
+	cols := make([]column, ncols)
+	for i := range cols {
+		c := info[22+i*8:]
+		cols[i] = column{le.Uint16(c[2:]), le.Uint16(c), int(le.Uint16(c[4:])), int(c[6]), int(c[7])}
+	}
+
+	var blocks [][]byte
+	if hnidRows == 0 || rowSize == 0 {
+		return nil, nil
+	} else if hnidRows&0x1f == 0 {
+		b, err := h.item(hnidRows)
+		if err != nil {
+			return nil, err
+		}
+		blocks = [][]byte{b}
+	} else {
+		sn, err := f.subnode(n, hnidRows)
+		if err != nil {
+			return nil, err
+		}
+		blocks = sn.blocks
+	}
+
+	var rows []props
+	for _, b := range blocks {
+		// Rows do not span blocks.
+		for off := 0; off+rowSize <= len(b); off += rowSize {
+			row := b[off : off+rowSize]
+			p := props{}
+			for _, c := range cols {
+				ceb := cebOffset + c.bit/8
+				if ceb >= len(row) || row[ceb]&(0x80>>(c.bit%8)) == 0 || c.offset+c.size > len(row) {
+					continue
+				}
+				cell := row[c.offset : c.offset+c.size]
+				switch c.typ {
+				case typeInt16, typeInt32, typeFloat32, typeFloat64, typeCurrency, typeAppTime, typeError, typeBool, typeInt64, typeTime:
+					p[c.id] = prop{c.typ, cell}
+				default:
+					if c.typ&0x1000 != 0 || len(cell) != 4 {
+						continue
+					}
+					data, err := h.hnid(le.Uint32(cell))
+					if err != nil {
+						return nil, fmt.Errorf("column %#04x: %w", c.id, err)
+					}
+					p[c.id] = prop{c.typ, data}
+				}
+			}
+			rows = append(rows, p)
+		}
+	}
+	return rows, nil
+}
+
+// str returns a string property, or an empty string.
+func (p props) str(id uint16) string {
+	v, ok := p[id]
+	if !ok {
+		return ""
+	}
+	switch v.typ {
+	case typeString:
+		u := make([]uint16, len(v.data)/2)
+		for i := range u {
+			u[i] = le.Uint16(v.data[2*i:])
+		}
+		for len(u) > 0 && u[len(u)-1] == 0 {
+			u = u[:len(u)-1]
+		}
+		return string(utf16.Decode(u))
+	case typeString8, typeBinary:
+		return decode8(bytes.TrimRight(v.data, "\x00"))
+	}
+	return ""
+}
+
+// decode8 returns a string for text in an 8-bit character set, assumed to be
+// latin1 if not valid utf-8.
+func decode8(b []byte) string {
+	if utf8.Valid(b) {
+		return string(b)
+	}
+	r := make([]rune, len(b))
+	for i, c := range b {
+		r[i] = rune(c)
+	}
+	return string(r)
+}
+
+// int returns an integer or boolean property.
+func (p props) int(id uint16) (int64, bool) {
+	v, ok := p[id]
+	if !ok {
+		return 0, false
+	}
+	switch {
+	case v.typ == typeInt16 && len(v.data) >= 2:
+		return int64(int16(le.Uint16(v.data))), true
+	case v.typ == typeInt32 && len(v.data) >= 4:
+		return int64(int32(le.Uint32(v.data))), true
+	case v.typ == typeBool && len(v.data) >= 1:
+		return int64(v.data[0]), true
+	case v.typ == typeInt64 && len(v.data) >= 8:
+		return int64(le.Uint64(v.data)), true
+	}
+	return 0, false
+}
+
+// time returns a time property, or the zero time.
+func (p props) time(id uint16) time.Time {
+	v, ok := p[id]
+	if !ok || v.typ != typeTime || len(v.data) < 8 {
+		return time.Time{}
+	}
+	// 100 nanosecond intervals since January 1, 1601.
+	ft := int64(le.Uint64(v.data))
+	const epochDiff = 116444736000000000 // Between 1601 and 1970.
+	if ft <= epochDiff {
+		return time.Time{}
+	}
+	ft -= epochDiff
+	return time.Unix(ft/1e7, (ft%1e7)*100)
+}
+
+// binary returns the data of a binary property.
+func (p props) binary(id uint16) []byte {
+	v, ok := p[id]
+	if !ok || v.typ != typeBinary {
+		return nil
+	}
+	return v.data
+}
+
+// permuteDecode decodes data of blocks with "compressible encryption", it is the
+// inverse of the substitution table used for encoding.
+var permuteDecode [256]byte
+
+func init() {
+	for i, c := range permuteEncode {
+		permuteDecode[c] = byte(i)
+	}
+}
+
+var permuteEncode = [256]byte{
+	65, 54, 19, 98, 168, 33, 110, 187, 244, 22, 204, 4, 127, 100, 232, 93,
+	30, 242, 203, 42, 116, 197, 94, 53, 210, 149, 71, 158, 150, 45, 154, 136,
+	76, 125, 132, 63, 219, 172, 49, 182, 72, 95, 246, 196, 216, 57, 139, 231,
+	35, 59, 56, 142, 200, 193, 223, 37, 177, 32, 165, 70, 96, 78, 156, 251,
+	170, 211, 86, 81, 69, 124, 85, 0, 7, 201, 43, 157, 133, 155, 9, 160,
+	143, 173, 179, 15, 99, 171, 137, 75, 215, 167, 21, 90, 113, 102, 66, 191,
+	38, 74, 107, 152, 250, 234, 119, 83, 178, 112, 5, 44, 253, 89, 58, 134,
+	126, 206, 6, 235, 130, 120, 87, 199, 141, 67, 175, 180, 28, 212, 91, 205,
+	226, 233, 39, 79, 195, 8, 114, 128, 207, 176, 239, 245, 40, 109, 190, 48,
+	77, 52, 146, 213, 14, 60, 34, 50, 229, 228, 249, 159, 194, 209, 10, 129,
+	18, 225, 238, 145, 131, 118, 227, 151, 230, 97, 138, 23, 121, 164, 183, 220,
+	144, 122, 92, 140, 2, 166, 202, 105, 222, 80, 26, 17, 147, 185, 82, 135,
+	88, 252, 237, 29, 55, 73, 27, 106, 224, 41, 51, 153, 189, 108, 217, 148,
+	243, 64, 84, 111, 240, 198, 115, 184, 214, 62, 101, 24, 68, 31, 221, 103,
+	16, 241, 12, 25, 236, 174, 3, 161, 20, 123, 169, 11, 255, 248, 163, 192,
+	162, 1, 247, 46, 188, 36, 104, 117, 13, 254, 186, 47, 181, 208, 218, 61,
+}
@@ -0,0 +1,365 @@
+package pst
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/mlog"
+)
+
+func tcompare(t *testing.T, got, exp any) {
+	t.Helper()
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("got:\n%#v\nexpected:\n%#v", got, exp)
+	}
+}
+
+func tcheck(t *testing.T, err error, msg string) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %s", msg, err)
+	}
+}
+
+// writer creates a minimal unicode pst file for tests. Each node is stored in a
+// single data block, and heaps have a single page.
+type writer struct {
+	buf     []byte
+	permute bool
+	nextBID uint64
+	blocks  [][3]uint64 // bid, offset, size
+	nodes   [][3]uint64 // nid, data bid, subnode bid
+}
+
+func (w *writer) block(data []byte, internal bool) uint64 {
+	w.nextBID += 4
+	bid := w.nextBID
+	if internal {
+		bid |= 2
+	}
+	ib := len(w.buf)
+	for _, c := range data {
+		if !internal && w.permute {
+			c = permuteEncode[c]
+		}
+		w.buf = append(w.buf, c)
+	}
+	size := (len(data) + 16 + 63) / 64 * 64
+	w.buf = append(w.buf, make([]byte, ib+size-len(w.buf))...)
+	binary.LittleEndian.PutUint16(w.buf[ib+size-16:], uint16(len(data)))
+	binary.LittleEndian.PutUint64(w.buf[ib+size-8:], bid)
+	w.blocks = append(w.blocks, [3]uint64{bid, uint64(ib), uint64(len(data))})
+	return bid
+}
+
+// subnodes adds a subnode block with nid and data block pairs.
+func (w *writer) subnodes(l ...any) uint64 {
+	b := make([]byte, 8)
+	b[0] = 0x02
+	binary.LittleEndian.PutUint16(b[2:], uint16(len(l)/2))
+	for i := 0; i < len(l); i += 2 {
+		e := make([]byte, 24)
+		binary.LittleEndian.PutUint32(e, l[i].(uint32))
+		binary.LittleEndian.PutUint64(e[8:], w.block(l[i+1].([]byte), false))
+		b = append(b, e...)
+	}
+	return w.block(b, true)
+}
+
+func (w *writer) node(nid uint32, data []byte, bidSub uint64) {
+	w.nodes = append(w.nodes, [3]uint64{uint64(nid), w.block(data, false), bidSub})
+}
+
+// btree writes the leaf entries in pages, with a parent page if needed, and
+// returns the offset of the root page.
+func (w *writer) btree(ptype byte, entries [][]byte) uint64 {
+	page := func(level byte, l [][]byte) uint64 {
+		p := make([]byte, 512)
+		for i, e := range l {
+			copy(p[i*len(e):], e)
+		}
+		p[488] = byte(len(l))
+		p[490] = byte(len(l[0]))
+		p[491] = level
+		p[496] = ptype
+		p[497] = ptype
+		ib := len(w.buf)
+		w.buf = append(w.buf, p...)
+		return uint64(ib)
+	}
+	per := 488 / len(entries[0])
+	if len(entries) <= per {
+		return page(0, entries)
+	}
+	var parents [][]byte
+	for len(entries) > 0 {
+		n := min(per, len(entries))
+		ib := page(0, entries[:n])
+		e := make([]byte, 24)
+		copy(e, entries[0][:8])
+		binary.LittleEndian.PutUint64(e[16:], ib)
+		parents = append(parents, e)
+		entries = entries[n:]
+	}
+	return page(1, parents)
+}
+
+func (w *writer) finish() []byte {
+	var bbt, nbt [][]byte
+	for _, b := range w.blocks {
+		e := make([]byte, 24)
+		binary.LittleEndian.PutUint64(e, b[0])
+		binary.LittleEndian.PutUint64(e[8:], b[1])
+		binary.LittleEndian.PutUint16(e[16:], uint16(b[2]))
+		bbt = append(bbt, e)
+	}
+	for _, n := range w.nodes {
+		e := make([]byte, 32)
+		binary.LittleEndian.PutUint64(e, n[0])
+		binary.LittleEndian.PutUint64(e[8:], n[1])
+		binary.LittleEndian.PutUint64(e[16:], n[2])
+		nbt = append(nbt, e)
+	}
+	bbtRoot := w.btree(0x80, bbt)
+	nbtRoot := w.btree(0x81, nbt)
+	copy(w.buf, "!BDN")
+	copy(w.buf[8:], "SM")
+	binary.LittleEndian.PutUint16(w.buf[10:], 23)
+	binary.LittleEndian.PutUint64(w.buf[224:], nbtRoot)
+	binary.LittleEndian.PutUint64(w.buf[240:], bbtRoot)
+	if w.permute {
+		w.buf[513] = 1
+	}
+	return w.buf
+}
+
+// heap builds a heap-on-node with a single page.
+type heapb struct {
+	items [][]byte
+}
+
+func (h *heapb) add(b []byte) uint32 {
+	h.items = append(h.items, b)
+	return uint32(len(h.items)) << 5
+}
+
+func (h *heapb) build(clientSig byte, root uint32) []byte {
+	b := make([]byte, 12)
+	b[2] = 0xec
+	b[3] = clientSig
+	binary.LittleEndian.PutUint32(b[4:], root)
+	offsets := []int{12}
+	for _, it := range h.items {
+		b = append(b, it...)
+		offsets = append(offsets, len(b))
+	}
+	binary.LittleEndian.PutUint16(b, uint16(len(b)))
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(h.items)))
+	b = binary.LittleEndian.AppendUint16(b, 0)
+	for _, o := range offsets {
+		b = binary.LittleEndian.AppendUint16(b, uint16(o))
+	}
+	return b
+}
+
+type tprop struct {
+	id, typ uint16
+	v       any // uint32 for inline values, []byte otherwise.
+}
+
+func (h *heapb) value(p tprop) uint32 {
+	if v, ok := p.v.(uint32); ok {
+		return v
+	}
+	return h.add(p.v.([]byte))
+}
+
+func pcData(l ...tprop) []byte {
+	h := &heapb{}
+	var records []byte
+	for _, p := range l {
+		records = binary.LittleEndian.AppendUint16(records, p.id)
+		records = binary.LittleEndian.AppendUint16(records, p.typ)
+		records = binary.LittleEndian.AppendUint32(records, h.value(p))
+	}
+	hidRecords := h.add(records)
+	hdr := []byte{0xb5, 2, 6, 0}
+	hdr = binary.LittleEndian.AppendUint32(hdr, hidRecords)
+	return h.build(0xbc, h.add(hdr))
+}
+
+// tcData builds a table with 4-byte columns.
+func tcData(cols []tprop, rows ...[]tprop) []byte {
+	h := &heapb{}
+	ncols := len(cols)
+	cebOffset := 4 * ncols
+	rowSize := cebOffset + (ncols+7)/8
+	var rowsData []byte
+	for _, r := range rows {
+		row := make([]byte, rowSize)
+		for _, p := range r {
+			for i, c := range cols {
+				if c.id == p.id {
+					binary.LittleEndian.PutUint32(row[4*i:], h.value(p))
+					row[cebOffset+i/8] |= 0x80 >> (i % 8)
+				}
+			}
+		}
+		rowsData = append(rowsData, row...)
+	}
+	hidRows := h.add(rowsData)
+	info := []byte{0x7c, byte(ncols)}
+	for _, v := range []int{cebOffset, cebOffset, cebOffset, rowSize} {
+		info = binary.LittleEndian.AppendUint16(info, uint16(v))
+	}
+	info = binary.LittleEndian.AppendUint32(info, 0)
+	info = binary.LittleEndian.AppendUint32(info, hidRows)
+	info = binary.LittleEndian.AppendUint32(info, 0)
+	for i, c := range cols {
+		info = binary.LittleEndian.AppendUint16(info, c.typ)
+		info = binary.LittleEndian.AppendUint16(info, c.id)
+		info = binary.LittleEndian.AppendUint16(info, uint16(4*i))
+		info = append(info, 4, byte(i))
+	}
+	return h.build(0x7c, h.add(info))
+}
+
+func ustr(s string) []byte {
+	var b []byte
+	for _, c := range utf16.Encode([]rune(s)) {
+		b = binary.LittleEndian.AppendUint16(b, c)
+	}
+	return b
+}
+
+func filetime(tm time.Time) []byte {
+	return binary.LittleEndian.AppendUint64(nil, uint64(tm.UnixNano()/100+116444736000000000))
+}
+
+func makePST(permute bool, received time.Time) []byte {
+	w := &writer{buf: make([]byte, 1024), permute: permute}
+
+	rowID := func(nid uint32) []tprop {
+		return []tprop{{propLtpRowID, typeInt32, nid}}
+	}
+	rowCols := []tprop{{propLtpRowID, typeInt32, nil}}
+
+	eid := make([]byte, 24)
+	binary.LittleEndian.PutUint32(eid[20:], 0x8022)
+	w.node(nidMessageStore, pcData(tprop{propIPMSubtree, typeBinary, eid}), 0)
+
+	// Top of personal folders, with an inbox, and a contacts folder that is skipped.
+	w.node(0x8022, pcData(tprop{propDisplayName, typeString, ustr("Top of Personal Folders")}), 0)
+	w.node(0x802d, tcData(rowCols, rowID(0x8042), rowID(0x8062)), 0)
+	w.node(0x8042, pcData(
+		tprop{propDisplayName, typeString, ustr("Inbox")},
+		tprop{propContentCount, typeInt32, uint32(2)},
+		tprop{propContainerClass, typeString, ustr("IPF.Note")},
+	), 0)
+	w.node(0x804d, tcData(rowCols, rowID(0x8082)), 0)
+	w.node(0x804e, tcData(rowCols, rowID(0x200024), rowID(0x200044)), 0)
+	w.node(0x8062, pcData(
+		tprop{propDisplayName, typeString, ustr("Contacts")},
+		tprop{propContainerClass, typeString, ustr("IPF.Contact")},
+	), 0)
+	w.node(0x8082, pcData(tprop{propDisplayName, typeString, ustr("Sub")}), 0)
+
+	// Received message, with transport headers and an attachment.
+	att := pcData(
+		tprop{propAttachMethod, typeInt32, uint32(1)},
+		tprop{propAttachLongFilename, typeString, ustr("a.txt")},
+		tprop{propAttachData, typeBinary, []byte("attached")},
+	)
+	w.node(0x200024, pcData(
+		tprop{propTransportHeaders, typeString, ustr("From: <a@example.org>\r\nTo: <b@example.org>\r\nSubject: hello\r\nContent-Type: text/plain;\r\n\tcharset=us-ascii\r\nMIME-Version: 1.0\r\n\r\n")},
+		tprop{propBody, typeString, ustr("hi\n")},
+		tprop{propHTML, typeBinary, []byte("<p>hi</p>")},
+		tprop{propMessageFlags, typeInt32, uint32(1)},
+		tprop{propFlagStatus, typeInt32, uint32(2)},
+		tprop{propDeliveryTime, typeTime, filetime(received)},
+	), w.subnodes(uint32(0x8025), att))
+
+	// Sent message, with headers from properties.
+	recipients := tcData(
+		[]tprop{{propRecipientType, typeInt32, nil}, {propDisplayName, typeString, nil}, {propSMTPAddress, typeString, nil}},
+		[]tprop{{propRecipientType, typeInt32, uint32(1)}, {propDisplayName, typeString, ustr("Bée")}, {propSMTPAddress, typeString, ustr("b@example.org")}},
+		[]tprop{{propRecipientType, typeInt32, uint32(2)}, {propSMTPAddress, typeString, ustr("c@example.org")}},
+	)
+	w.node(0x200044, pcData(
+		tprop{propSubject, typeString, ustr("\x01\x04Re: tést")},
+		tprop{propSenderName, typeString, ustr("A")},
+		tprop{propSenderSMTPAddress, typeString, ustr("a@example.org")},
+		tprop{propBody, typeString, ustr("reply\n")},
+		tprop{propLastVerb, typeInt32, uint32(102)},
+		tprop{propClientSubmitTime, typeTime, filetime(received)},
+		tprop{propMessageID, typeString8, []byte("<id@example.org>")},
+	), w.subnodes(uint32(nidRecipientTable), recipients))
+
+	return w.finish()
+}
+
+func TestPST(t *testing.T) {
+	log := mlog.New("pst", nil)
+	received := time.Date(2020, time.January, 2, 3, 4, 5, 0, time.UTC)
+
+	for _, permute := range []bool{false, true} {
+		f, err := Open(bytes.NewReader(makePST(permute, received)))
+		tcheck(t, err, "open")
+
+		folders, err := f.Folders()
+		tcheck(t, err, "folders")
+		tcompare(t, folders, []Folder{{0x8042, []string{"Inbox"}, 2}, {0x8082, []string{"Inbox", "Sub"}, 0}})
+
+		msgs, err := f.Messages(folders[1])
+		tcheck(t, err, "messages")
+		tcompare(t, len(msgs), 0)
+		msgs, err = f.Messages(folders[0])
+		tcheck(t, err, "messages")
+		tcompare(t, msgs, []uint32{0x200024, 0x200044})
+
+		m, err := f.Message(msgs[0])
+		tcheck(t, err, "message")
+		tcompare(t, m.Seen, true)
+		tcompare(t, m.Flagged, true)
+		tcompare(t, m.Answered, false)
+		tcompare(t, m.Received.Equal(received), true)
+		data := string(m.Data)
+		if !strings.HasPrefix(data, "From: <a@example.org>\r\nTo: <b@example.org>\r\nSubject: hello\r\nMIME-Version: 1.0\r\nContent-Type: multipart/mixed;") {
+			t.Fatalf("unexpected headers:\n%s", data)
+		}
+		p, err := message.EnsurePart(log.Logger, true, bytes.NewReader(m.Data), int64(len(m.Data)))
+		tcheck(t, err, "parse message")
+		tcompare(t, p.MediaType+"/"+p.MediaSubType, "MULTIPART/MIXED")
+		tcompare(t, len(p.Parts), 2)
+		alt := p.Parts[0]
+		tcompare(t, alt.MediaSubType, "ALTERNATIVE")
+		tcompare(t, len(alt.Parts), 2)
+		tcompare(t, alt.Parts[1].MediaSubType, "HTML")
+		att := p.Parts[1]
+		tcompare(t, att.ContentTypeParams["name"], "a.txt")
+		buf, err := bytes.NewBuffer(nil), error(nil)
+		_, err = buf.ReadFrom(att.Reader())
+		tcheck(t, err, "read attachment")
+		tcompare(t, buf.String(), "attached")
+
+		m, err = f.Message(msgs[1])
+		tcheck(t, err, "message")
+		tcompare(t, m.Seen, false)
+		tcompare(t, m.Answered, true)
+		p, err = message.EnsurePart(log.Logger, true, bytes.NewReader(m.Data), int64(len(m.Data)))
+		tcheck(t, err, "parse message")
+		tcompare(t, p.Envelope.Subject, "Re: tést")
+		tcompare(t, p.Envelope.MessageID, "<id@example.org>")
+		tcompare(t, p.Envelope.Date.Equal(received), true)
+		tcompare(t, p.Envelope.From, []message.Address{{Name: "A", User: "a", Host: "example.org"}})
+		tcompare(t, p.Envelope.To, []message.Address{{Name: "Bée", User: "b", Host: "example.org"}})
+		tcompare(t, p.Envelope.CC, []message.Address{{User: "c", Host: "example.org"}})
+		tcompare(t, p.MediaSubType, "PLAIN")
+	}
+}
@@ -0,0 +1,472 @@
+// Package quarantine holds incoming messages that would otherwise be rejected,
+// for review by admins and the accounts they were destined for.
+//
+// A quarantined message is accepted from the remote SMTP server, with the
+// decision for delivery postponed. Admins can release messages, delivering them
+// to the mailbox they were destined for, or remove them. Accounts can do the same
+// for their own messages. Messages are removed automatically when they expire.
+// Accounts can be sent a daily digest of their newly quarantined messages.
+//
+// Message files are stored in the "quarantine" directory in the data directory,
+// named after the ID of the quarantined message. Like message files in the
+// queue, they are not encrypted with the message encryption key.
+package quarantine
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/mox/accounting"
+	"github.com/mjl-/mox/admindb"
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/store"
+)
+
+var metricQuarantine = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "mox_quarantine_total",
+		Help: "Messages added to and removed from the quarantine, by action.",
+	},
+	[]string{
+		"action", // hold, release, remove, expire
+	},
+)
+
+// DefaultExpiration is the period after which quarantined messages are removed,
+// if no other period is configured.
+const DefaultExpiration = 30 * 24 * time.Hour
+
+// Interval between checks for expired messages and pending digests.
+const interval = time.Hour
+
+// Digests are sent to an account at most once per digestInterval.
+const digestInterval = 24 * time.Hour
+
+// Releases and removals are serialized, so a message cannot be delivered twice.
+var mutex sync.Mutex
+
+// Holds returns whether a message that would be rejected for reason must be
+// quarantined instead.
+func Holds(reason string) bool {
+	q := mox.Conf.Static.Quarantine
+	return q != nil && slices.Contains(q.Reasons, reason)
+}
+
+// Path returns the path of the message file of a quarantined message.
+func Path(id int64) string {
+	return mox.DataDirPath(filepath.Join("quarantine", fmt.Sprintf("%d", id)))
+}
+
+// Add holds the message in r in quarantine, setting the ID and expiration time of
+// qm.
+func Add(ctx context.Context, log mlog.Log, qm *admindb.Quarantined, r io.Reader) (rerr error) {
+	expiration := DefaultExpiration
+	if q := mox.Conf.Static.Quarantine; q != nil && q.Expiration > 0 {
+		expiration = q.Expiration
+	}
+	if qm.Received.IsZero() {
+		qm.Received = time.Now()
+	}
+	qm.Expires = qm.Received.Add(expiration)
+	if err := admindb.QuarantineAdd(ctx, qm); err != nil {
+		return fmt.Errorf("adding quarantined message: %w", err)
+	}
+	defer func() {
+		if rerr != nil {
+			err := admindb.QuarantineRemove(context.WithoutCancel(ctx), qm.ID)
+			log.Check(err, "removing quarantined message after error")
+		}
+	}()
+
+	p := Path(qm.ID)
+	if err := os.MkdirAll(filepath.Dir(p), 0770); err != nil {
+		return fmt.Errorf("creating quarantine directory: %w", err)
+	}
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0660)
+	if err != nil {
+		return fmt.Errorf("creating message file: %w", err)
+	}
+	defer func() {
+		if f != nil {
+			err := f.Close()
+			log.Check(err, "closing message file")
+		}
+		if rerr != nil {
+			err := os.Remove(p)
+			log.Check(err, "removing message file after error")
+		}
+	}()
+	if _, err := io.Copy(f, r); err != nil {
+		return fmt.Errorf("writing message file: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("sync message file: %w", err)
+	}
+	err = f.Close()
+	f = nil
+	if err != nil {
+		return fmt.Errorf("closing message file: %w", err)
+	}
+
+	metricQuarantine.WithLabelValues("hold").Inc()
+	log.Info("message quarantined",
+		slog.Int64("id", qm.ID),
+		slog.String("account", qm.Account),
+		slog.String("reason", qm.Reason))
+	return nil
+}
+
+// Release delivers a quarantined message to the mailbox it was destined for, and
+// removes it from the quarantine. If account is not empty, the message must be
+// destined for that account.
+func Release(ctx context.Context, log mlog.Log, account string, id int64) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	qm, err := get(ctx, account, id)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(Path(id))
+	if err != nil {
+		return fmt.Errorf("open message file: %w", err)
+	}
+	defer func() {
+		err := f.Close()
+		log.Check(err, "closing message file")
+	}()
+	fi, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("stat message file: %w", err)
+	}
+
+	acc, err := store.OpenAccount(log, qm.Account)
+	if err != nil {
+		return fmt.Errorf("open account: %w", err)
+	}
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	m := store.Message{
+		Received: qm.Received,
+		RemoteIP: qm.RemoteIP,
+		Size:     fi.Size(),
+		Sealed:   store.MessageSealed{MailFrom: qm.MailFrom},
+	}
+	if addr, err := smtp.ParseAddress(qm.MailFrom); err == nil {
+		m.Sealed.MailFromLocalpart = addr.Localpart
+		m.MailFromDomain = addr.Domain.Name()
+	}
+	var rcptDomain dns.Domain
+	if addr, err := smtp.ParseAddress(qm.RcptTo); err == nil {
+		m.Sealed.RcptToLocalpart = addr.Localpart
+		m.Sealed.RcptToDomain = addr.Domain.Name()
+		rcptDomain = addr.Domain
+	}
+	mailbox := qm.Mailbox
+	if mailbox == "" {
+		mailbox = "Inbox"
+	}
+	acc.WithWLock(func() {
+		err = acc.DeliverMailbox(log, mailbox, &m, f)
+	})
+	if err != nil {
+		return fmt.Errorf("delivering message: %w", err)
+	}
+	accounting.Received(ctx, log, qm.Account, rcptDomain, m.Size)
+
+	if err := remove(ctx, log, qm.ID); err != nil {
+		return err
+	}
+	metricQuarantine.WithLabelValues("release").Inc()
+	log.Info("quarantined message released", slog.Int64("id", qm.ID), slog.String("account", qm.Account), slog.String("mailbox", mailbox))
+	return nil
+}
+
+// Remove removes a quarantined message without delivering it. If account is not
+// empty, the message must be destined for that account.
+func Remove(ctx context.Context, log mlog.Log, account string, id int64) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	qm, err := get(ctx, account, id)
+	if err != nil {
+		return err
+	}
+	if err := remove(ctx, log, qm.ID); err != nil {
+		return err
+	}
+	metricQuarantine.WithLabelValues("remove").Inc()
+	log.Info("quarantined message removed", slog.Int64("id", qm.ID), slog.String("account", qm.Account))
+	return nil
+}
+
+// Headers returns the header section of a quarantined message, e.g. for
+// reviewing the Authentication-Results and spam scanner results. If account is
+// not empty, the message must be destined for that account.
+func Headers(ctx context.Context, account string, id int64) (string, error) {
+	qm, err := get(ctx, account, id)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(Path(qm.ID))
+	if err != nil {
+		return "", fmt.Errorf("open message file: %w", err)
+	}
+	defer f.Close()
+	buf, err := message.ReadHeaders(bufio.NewReader(f))
+	if err != nil {
+		return "", fmt.Errorf("reading message headers: %w", err)
+	}
+	return string(buf), nil
+}
+
+// PurgeAccount removes all quarantined messages of an account, e.g. when the
+// account is purged. The number of removed messages is returned.
+func PurgeAccount(ctx context.Context, log mlog.Log, account string) (int, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	l, err := admindb.QuarantineList(ctx, account)
+	if err != nil {
+		return 0, err
+	}
+	for i, qm := range l {
+		if err := remove(ctx, log, qm.ID); err != nil {
+			return i, err
+		}
+	}
+	return len(l), nil
+}
+
+// get returns a quarantined message, checking it belongs to account if not empty.
+func get(ctx context.Context, account string, id int64) (admindb.Quarantined, error) {
+	qm, err := admindb.QuarantineGet(ctx, id)
+	if err == nil && account != "" && qm.Account != account {
+		err = fmt.Errorf("%w: no quarantined message with id %d", admindb.ErrNotFound, id)
+	}
+	return qm, err
+}
+
+// remove removes the message file and database record of a quarantined message.
+func remove(ctx context.Context, log mlog.Log, id int64) error {
+	if err := admindb.QuarantineRemove(ctx, id); err != nil {
+		return fmt.Errorf("removing quarantined message: %w", err)
+	}
+	err := os.Remove(Path(id))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Errorx("removing message file of quarantined message", err, slog.Int64("id", id))
+	}
+	return nil
+}
+
+// Expire removes quarantined messages that expired before now.
+func Expire(ctx context.Context, log mlog.Log, now time.Time) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	l, err := admindb.QuarantineExpired(ctx, now)
+	if err != nil {
+		log.Errorx("listing expired quarantined messages", err)
+		return
+	}
+	for _, qm := range l {
+		if err := remove(ctx, log, qm.ID); err != nil {
+			log.Errorx("removing expired quarantined message", err, slog.Int64("id", qm.ID))
+			continue
+		}
+		metricQuarantine.WithLabelValues("expire").Inc()
+	}
+	if len(l) > 0 {
+		log.Info("expired quarantined messages removed", slog.Int("count", len(l)))
+	}
+}
+
+// SendDigests delivers a digest message to the accounts with quarantined messages
+// not yet included in a digest, unless the account was sent a digest less than a
+// day ago.
+func SendDigests(ctx context.Context, log mlog.Log, now time.Time) {
+	l, err := admindb.QuarantineList(ctx, "")
+	if err != nil {
+		log.Errorx("listing quarantined messages", err)
+		return
+	}
+	pending := map[string][]admindb.Quarantined{}
+	recent := map[string]bool{}
+	for _, qm := range l {
+		if qm.Digested.IsZero() {
+			pending[qm.Account] = append(pending[qm.Account], qm)
+		} else if now.Sub(qm.Digested) < digestInterval {
+			recent[qm.Account] = true
+		}
+	}
+	var accounts []string
+	for account := range pending {
+		if !recent[account] {
+			accounts = append(accounts, account)
+		}
+	}
+	sort.Strings(accounts)
+	for _, account := range accounts {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := digest(log, account, pending[account]); err != nil {
+			log.Errorx("delivering quarantine digest", err, slog.String("account", account))
+			continue
+		}
+		var ids []int64
+		for _, qm := range pending[account] {
+			ids = append(ids, qm.ID)
+		}
+		err := admindb.QuarantineDigested(ctx, ids, now)
+		log.Check(err, "marking quarantined messages as digested", slog.String("account", account))
+	}
+}
+
+// digest delivers a message listing the quarantined messages to the account.
+func digest(log mlog.Log, account string, l []admindb.Quarantined) error {
+	if _, ok := mox.Conf.Account(account); !ok {
+		return nil
+	}
+
+	var b strings.Builder
+	for _, qm := range l {
+		from := qm.MsgFrom
+		if from == "" {
+			from = qm.MailFrom
+		}
+		fmt.Fprintf(&b, "- %s, from %s, to %s: %s\n", qm.Received.Format("2006-01-02 15:04"), from, qm.RcptTo, qm.Subject)
+	}
+	link := "the account web interface"
+	if u := accountURL(); u != "" {
+		link = u
+	}
+	text := fmt.Sprintf(`Hi!
+
+The following incoming messages for your account were held in quarantine
+because they look suspicious, e.g. like spam or malware:
+
+%s
+The messages were not delivered to your mailboxes. If you expected one of these
+messages, you can release it at %s.
+Quarantined messages are removed automatically after a while.
+
+Cheers,
+mox
+`, b.String(), link)
+
+	acc, err := store.OpenAccount(log, account)
+	if err != nil {
+		return fmt.Errorf("open account: %v", err)
+	}
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+	f, err := store.CreateMessageTemp(log, "quarantine-digest")
+	if err != nil {
+		return fmt.Errorf("creating temporary message file: %v", err)
+	}
+	defer store.CloseRemoveTempFile(log, f, "message for quarantine digest")
+
+	m := store.Message{Received: time.Now()}
+	subject := fmt.Sprintf("mox: %d message(s) held in quarantine", len(l))
+	n, err := fmt.Fprintf(f, "Date: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: 8-bit\r\n\r\n%s", time.Now().Format(message.RFC5322Z), subject, strings.ReplaceAll(text, "\n", "\r\n"))
+	if err != nil {
+		return fmt.Errorf("writing temporary message file: %v", err)
+	}
+	m.Size = int64(n)
+
+	acc.WithWLock(func() {
+		err = acc.DeliverMailbox(log, "Inbox", &m, f)
+	})
+	return err
+}
+
+// accountURL returns the URL to the account web interface, for the first listener
+// with the account web interface enabled over HTTPS. Empty if there is none.
+func accountURL() string {
+	var names []string
+	for name := range mox.Conf.Static.Listeners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		l := mox.Conf.Static.Listeners[name]
+		if !l.AccountHTTPS.Enabled {
+			continue
+		}
+		host := mox.Conf.Static.HostnameDomain
+		if !l.HostnameDomain.IsZero() {
+			host = l.HostnameDomain
+		}
+		path := l.AccountHTTPS.Path
+		if path == "" {
+			path = "/"
+		}
+		return "https://" + host.ASCII + path + "#quarantine"
+	}
+	return ""
+}
+
+// Start periodically removes expired quarantined messages, and sends digests if
+// enabled.
+func Start() {
+	log := mlog.New("quarantine", nil)
+
+	go func() {
+		timer := time.NewTimer(5 * time.Minute)
+		defer timer.Stop()
+		for {
+			select {
+			case <-mox.Shutdown.Done():
+				return
+			case <-timer.C:
+			}
+
+			run(log.WithCid(mox.Cid()))
+			timer.Reset(interval)
+		}
+	}()
+}
+
+func run(log mlog.Log) {
+	defer func() {
+		x := recover()
+		if x != nil {
+			log.Error("recover from panic", slog.Any("panic", x))
+			debug.PrintStack()
+			metrics.PanicInc(metrics.Quarantine)
+		}
+	}()
+
+	now := time.Now()
+	Expire(mox.Shutdown, log, now)
+	if q := mox.Conf.Static.Quarantine; q != nil && q.Digest {
+		SendDigests(mox.Shutdown, log, now)
+	}
+}
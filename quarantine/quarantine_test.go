@@ -0,0 +1,147 @@
+package quarantine
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/admindb"
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/store"
+)
+
+var ctxbg = context.Background()
+
+func tcheck(t *testing.T, err error, msg string) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %s", msg, err)
+	}
+}
+
+func TestQuarantine(t *testing.T) {
+	log := mlog.New("quarantine", nil)
+	os.RemoveAll("../testdata/quarantine/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/quarantine/mox.conf")
+	mox.ConfigDynamicPath = filepath.FromSlash("../testdata/quarantine/domains.conf")
+	mox.MustLoadConfig(true, false)
+	defer store.Switchboard()()
+	err := admindb.Init()
+	tcheck(t, err, "admindb init")
+	defer admindb.Close()
+
+	mox.Conf.Static.Quarantine = &config.Quarantine{Reasons: []string{"hash-blocklisted"}, Expiration: time.Hour}
+	defer func() {
+		mox.Conf.Static.Quarantine = nil
+	}()
+	if !Holds("hash-blocklisted") || Holds("dns-blocklisted") {
+		t.Fatalf("bad holds for reasons")
+	}
+
+	const msg = "From: <remote@example.org>\r\nSubject: test\r\n\r\nbody\r\n"
+	add := func(subject string) admindb.Quarantined {
+		t.Helper()
+		qm := admindb.Quarantined{
+			Account:  "mjl",
+			Reason:   "hash-blocklisted",
+			MailFrom: "remote@example.org",
+			RcptTo:   "mjl@mox.example",
+			Subject:  subject,
+			Size:     int64(len(msg)),
+		}
+		err := Add(ctxbg, log, &qm, strings.NewReader(msg))
+		tcheck(t, err, "add")
+		return qm
+	}
+
+	inboxCount := func() int {
+		t.Helper()
+		acc, err := store.OpenAccount(log, "mjl")
+		tcheck(t, err, "open account")
+		defer func() {
+			err := acc.Close()
+			tcheck(t, err, "close account")
+		}()
+		var n int
+		err = acc.DB.Read(ctxbg, func(tx *bstore.Tx) error {
+			mb, err := acc.MailboxFind(tx, "Inbox")
+			if err != nil {
+				return err
+			}
+			n, err = bstore.QueryTx[store.Message](tx).FilterNonzero(store.Message{MailboxID: mb.ID}).FilterEqual("Expunged", false).Count()
+			return err
+		})
+		tcheck(t, err, "count inbox messages")
+		return n
+	}
+
+	qm1 := add("first")
+	qm2 := add("second")
+	if time.Until(qm1.Expires) > time.Hour || time.Until(qm1.Expires) < 59*time.Minute {
+		t.Fatalf("unexpected expiration %v", qm1.Expires)
+	}
+
+	headers, err := Headers(ctxbg, "mjl", qm1.ID)
+	tcheck(t, err, "headers")
+	if headers != "From: <remote@example.org>\r\nSubject: test\r\n" {
+		t.Fatalf("got headers %q", headers)
+	}
+
+	// Accounts can only access their own messages.
+	err = Release(ctxbg, log, "other", qm1.ID)
+	if !errors.Is(err, admindb.ErrNotFound) {
+		t.Fatalf("release for other account, got err %v, expected ErrNotFound", err)
+	}
+
+	err = Release(ctxbg, log, "mjl", qm1.ID)
+	tcheck(t, err, "release")
+	if n := inboxCount(); n != 1 {
+		t.Fatalf("got %d messages in inbox after release, expected 1", n)
+	}
+	if _, err := os.Stat(Path(qm1.ID)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("message file still present after release, err %v", err)
+	}
+	err = Release(ctxbg, log, "", qm1.ID)
+	if !errors.Is(err, admindb.ErrNotFound) {
+		t.Fatalf("second release, got err %v, expected ErrNotFound", err)
+	}
+
+	// Digest for pending message, sent once per day.
+	SendDigests(ctxbg, log, time.Now())
+	if n := inboxCount(); n != 2 {
+		t.Fatalf("got %d messages in inbox after digest, expected 2", n)
+	}
+	qm3 := add("third")
+	SendDigests(ctxbg, log, time.Now())
+	if n := inboxCount(); n != 2 {
+		t.Fatalf("got %d messages in inbox after second digest, expected 2", n)
+	}
+
+	err = Remove(ctxbg, log, "", qm2.ID)
+	tcheck(t, err, "remove")
+
+	Expire(ctxbg, log, time.Now().Add(2*time.Hour))
+	l, err := admindb.QuarantineList(ctxbg, "")
+	tcheck(t, err, "list")
+	if len(l) != 0 {
+		t.Fatalf("got %d quarantined messages after expire, expected 0", len(l))
+	}
+	if _, err := os.Stat(Path(qm3.ID)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("message file still present after expire, err %v", err)
+	}
+
+	add("fourth")
+	n, err := PurgeAccount(ctxbg, log, "mjl")
+	tcheck(t, err, "purge account")
+	if n != 1 {
+		t.Fatalf("purged %d messages, expected 1", n)
+	}
+}
@@ -204,6 +204,10 @@ Prints the message with its ID, last and next delivery attempts, last error.
 	if len(c.Parse()) != 0 {
 		c.Usage()
 	}
+	if rc := xremote(); rc != nil {
+		remotecmdQueueList(rc, f, s)
+		return
+	}
 	mustLoadConfig()
 	ctlcmdQueueList(xctl(), f, s)
 }
@@ -230,6 +234,10 @@ otherwise handled by the admin.
 	if len(c.Parse()) != 0 {
 		c.Usage()
 	}
+	if rc := xremote(); rc != nil {
+		remotecmdQueueHoldSet(rc, f, true)
+		return
+	}
 	mustLoadConfig()
 	ctlcmdQueueHoldSet(xctl(), f, true)
 }
@@ -246,6 +254,10 @@ delivery attempt. See the "queue schedule" command.
 	if len(c.Parse()) != 0 {
 		c.Usage()
 	}
+	if rc := xremote(); rc != nil {
+		remotecmdQueueHoldSet(rc, f, false)
+		return
+	}
 	mustLoadConfig()
 	ctlcmdQueueHoldSet(xctl(), f, false)
 }
@@ -286,6 +298,10 @@ Schedule immediate delivery with "mox queue schedule -now 0".
 	}
 	d, err := time.ParseDuration(args[0])
 	xcheckf(err, "parsing duration %q", args[0])
+	if rc := xremote(); rc != nil {
+		remotecmdQueueSchedule(rc, f, fromNow, d)
+		return
+	}
 	mustLoadConfig()
 	ctlcmdQueueSchedule(xctl(), f, fromNow, d)
 }
@@ -434,6 +450,10 @@ the message, use "queue dump" before removing.
 	if len(c.Parse()) != 0 {
 		c.Usage()
 	}
+	if rc := xremote(); rc != nil {
+		remotecmdQueueDrop(rc, f)
+		return
+	}
 	mustLoadConfig()
 	ctlcmdQueueDrop(xctl(), f)
 }
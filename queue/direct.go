@@ -19,6 +19,8 @@ import (
 	"github.com/mjl-/adns"
 	"github.com/mjl-/bstore"
 
+	"github.com/mjl-/mox/accounting"
+	"github.com/mjl-/mox/admindb"
 	"github.com/mjl-/mox/config"
 	"github.com/mjl-/mox/dns"
 	"github.com/mjl-/mox/dsn"
@@ -30,6 +32,7 @@ import (
 	"github.com/mjl-/mox/smtpclient"
 	"github.com/mjl-/mox/store"
 	"github.com/mjl-/mox/tlsrpt"
+	"github.com/mjl-/mox/tracing"
 	"github.com/mjl-/mox/webhook"
 )
 
@@ -112,7 +115,7 @@ type msgResp struct {
 // domain (MTA-STS), its policy type can be empty, in which case there is no
 // information (e.g. internal failure). hostResults are per-host details (DANE, one
 // per MX target).
-func deliverDirect(qlog mlog.Log, resolver dns.Resolver, dialer smtpclient.Dialer, ourHostname dns.Domain, transportName string, transportDirect *config.TransportDirect, msgs []*Msg, backoff time.Duration) (recipientDomainResult tlsrpt.Result, hostResults []tlsrpt.Result) {
+func deliverDirect(ctx context.Context, qlog mlog.Log, resolver dns.Resolver, dialer smtpclient.Dialer, ourHostname dns.Domain, transportName string, transportDirect *config.TransportDirect, msgs []*Msg, backoff time.Duration) (recipientDomainResult tlsrpt.Result, hostResults []tlsrpt.Result) {
 	// High-level approach:
 	// - Resolve domain to deliver to (CNAME), and determine hosts to try to deliver to (MX)
 	// - Get MTA-STS policy for domain (optional). If present, only deliver to its
@@ -138,7 +141,6 @@ func deliverDirect(qlog mlog.Log, resolver dns.Resolver, dialer smtpclient.Diale
 	// possibly a chain. If there are no MX records, it can be an IP or the host
 	// directly.
 	origNextHop := m0.RecipientDomain.Domain
-	ctx := mox.Shutdown
 	haveMX, origNextHopAuthentic, expandedNextHopAuthentic, expandedNextHop, hosts, permanent, err := smtpclient.GatherDestinations(ctx, qlog.Logger, resolver, m0.RecipientDomain)
 	if err != nil {
 		// If this is a DNSSEC authentication error, we'll collect it for TLS reporting.
@@ -254,7 +256,7 @@ func deliverDirect(qlog mlog.Log, resolver dns.Resolver, dialer smtpclient.Diale
 			msgResps[i] = &msgResp{msg: msgs[i]}
 		}
 
-		result := deliverHost(nqlog, resolver, dialer, ourHostname, transportName, transportDirect, h, enforceMTASTS, haveMX, origNextHopAuthentic, origNextHop, expandedNextHopAuthentic, expandedNextHop, msgResps, tlsMode, tlsPKIX, &recipientDomainResult)
+		result := deliverHost(ctx, nqlog, resolver, dialer, ourHostname, transportName, transportDirect, h, enforceMTASTS, haveMX, origNextHopAuthentic, origNextHop, expandedNextHopAuthentic, expandedNextHop, msgResps, tlsMode, tlsPKIX, &recipientDomainResult)
 
 		var zerotype tlsrpt.PolicyType
 		if result.hostResult.Policy.Type != zerotype {
@@ -281,7 +283,7 @@ func deliverDirect(qlog mlog.Log, resolver dns.Resolver, dialer smtpclient.Diale
 				slog.Bool("enforcemtasts", enforceMTASTS),
 				slog.Bool("tlsdane", result.tlsDANE),
 				slog.Any("requiretls", m0.RequireTLS))
-			result = deliverHost(nqlog, resolver, dialer, ourHostname, transportName, transportDirect, h, enforceMTASTS, haveMX, origNextHopAuthentic, origNextHop, expandedNextHopAuthentic, expandedNextHop, msgResps, smtpclient.TLSSkip, false, &tlsrpt.Result{})
+			result = deliverHost(ctx, nqlog, resolver, dialer, ourHostname, transportName, transportDirect, h, enforceMTASTS, haveMX, origNextHopAuthentic, origNextHop, expandedNextHopAuthentic, expandedNextHop, msgResps, smtpclient.TLSSkip, false, &tlsrpt.Result{})
 		}
 
 		remoteMTA = dsn.NameIP{Name: h.XString(false), IP: remoteIP}
@@ -301,9 +303,11 @@ func deliverDirect(qlog mlog.Log, resolver dns.Resolver, dialer smtpclient.Diale
 
 		delMsgs := make([]Msg, len(result.delivered))
 		for i, mr := range result.delivered {
-			mqlog := nqlog.With(slog.Int64("msgid", mr.msg.ID), slog.Any("recipient", mr.msg.Recipient()))
+			mqlog := nqlog.With(slog.Int64("msgid", mr.msg.ID), slog.Any("recipient", mr.msg.Recipient()), slog.String("messageid", mr.msg.MessageID))
 			mqlog.Info("delivered from queue")
 			mr.msg.markResult(0, "", "", true)
+			messageEvent(mqlog, *mr.msg, admindb.EventDelivered, remoteMTA, "", "")
+			accounting.Sent(context.Background(), mqlog, mr.msg.SenderAccount, mr.msg.SenderDomain.Domain, mr.msg.Size)
 			delMsgs[i] = *mr.msg
 		}
 		if len(delMsgs) > 0 {
@@ -397,7 +401,7 @@ type deliverResult struct {
 //
 // deliverHost may send a message multiple times: if the server doesn't accept
 // multiple recipients for a message.
-func deliverHost(log mlog.Log, resolver dns.Resolver, dialer smtpclient.Dialer, ourHostname dns.Domain, transportName string, transportDirect *config.TransportDirect, host dns.IPDomain, enforceMTASTS, haveMX, origNextHopAuthentic bool, origNextHop dns.Domain, expandedNextHopAuthentic bool, expandedNextHop dns.Domain, msgResps []*msgResp, tlsMode smtpclient.TLSMode, tlsPKIX bool, recipientDomainResult *tlsrpt.Result) (result deliverResult) {
+func deliverHost(ctx context.Context, log mlog.Log, resolver dns.Resolver, dialer smtpclient.Dialer, ourHostname dns.Domain, transportName string, transportDirect *config.TransportDirect, host dns.IPDomain, enforceMTASTS, haveMX, origNextHopAuthentic bool, origNextHop dns.Domain, expandedNextHopAuthentic bool, expandedNextHop dns.Domain, msgResps []*msgResp, tlsMode smtpclient.TLSMode, tlsPKIX bool, recipientDomainResult *tlsrpt.Result) (result deliverResult) {
 	// About attempting delivery to multiple addresses of a host: ../rfc/5321:3898
 
 	m0 := msgResps[0].msg
@@ -407,6 +411,11 @@ func deliverHost(log mlog.Log, resolver dns.Resolver, dialer smtpclient.Dialer,
 	var remoteIP net.IP
 	var hostResult tlsrpt.Result
 	start := time.Now()
+	hostctx, span := tracing.StartKind(ctx, "queue.deliverhost", tracing.KindClient,
+		tracing.String("net.peer.name", host.String()),
+		tracing.String("tls.mode", string(tlsMode)),
+		tracing.Bool("tls.pkix", tlsPKIX),
+	)
 	defer func() {
 		result.tlsDANE = tlsDANE
 		result.remoteIP = remoteIP
@@ -424,6 +433,14 @@ func deliverHost(log mlog.Log, resolver dns.Resolver, dialer smtpclient.Dialer,
 		d := float64(time.Since(start)) / float64(time.Second)
 		metricDelivery.WithLabelValues(fmt.Sprintf("%d", m0.Attempts), transportName, mode, r).Observe(d)
 
+		span.SetAttrs(
+			tracing.String("queue.result", r),
+			tracing.String("net.peer.addr", remoteIP.String()),
+			tracing.Bool("tls.dane", tlsDANE),
+		)
+		span.SetError(result.err)
+		span.End()
+
 		log.Debugx("queue deliverhost result", result.err,
 			slog.Any("host", host),
 			slog.Int("attempt", m0.Attempts),
@@ -449,7 +466,7 @@ func deliverHost(log mlog.Log, resolver dns.Resolver, dialer smtpclient.Dialer,
 		log.Check(err, "closing message after delivery attempt")
 	}()
 
-	ctx, cancel := context.WithTimeout(mox.Shutdown, 30*time.Second)
+	ctx, cancel := context.WithTimeout(hostctx, 30*time.Second)
 	defer cancel()
 
 	// We must lookup the IPs for the host name before checking DANE TLSA records. And
@@ -622,7 +639,7 @@ func deliverHost(log mlog.Log, resolver dns.Resolver, dialer smtpclient.Dialer,
 
 	// todo future: get closer to timeouts specified in rfc? ../rfc/5321:3610
 	log = log.With(slog.Any("remoteip", remoteIP))
-	ctx, cancel = context.WithTimeout(mox.Shutdown, 30*time.Minute)
+	ctx, cancel = context.WithTimeout(hostctx, 30*time.Minute)
 	defer cancel()
 	mox.Connections.Register(conn, "smtpclient", "queue")
 
@@ -17,8 +17,10 @@ import (
 
 	"github.com/mjl-/bstore"
 
+	"github.com/mjl-/mox/admindb"
 	"github.com/mjl-/mox/dns"
 	"github.com/mjl-/mox/dsn"
+	"github.com/mjl-/mox/i18n"
 	"github.com/mjl-/mox/message"
 	"github.com/mjl-/mox/mlog"
 	"github.com/mjl-/mox/mox-"
@@ -95,6 +97,11 @@ func failMsgsTx(qlog mlog.Log, tx *bstore.Tx, msgs []*Msg, dialedIPs map[string]
 		ids[i] = m.ID
 	}
 
+	var eventResult string
+	if code != 0 {
+		eventResult = strings.TrimSpace(fmt.Sprintf("%d %s", code, secodeOpt))
+	}
+
 	if permanent || m0.MaxAttempts == 0 && m0.Attempts >= 8 || m0.MaxAttempts > 0 && m0.Attempts >= m0.MaxAttempts {
 		event = webhook.EventFailed
 		if errors.Is(err, errSuppressed) {
@@ -110,6 +117,7 @@ func failMsgsTx(qlog mlog.Log, tx *bstore.Tx, msgs []*Msg, dialedIPs map[string]
 
 			qmlog := qlog.With(slog.Int64("msgid", rm.ID), slog.Any("recipient", m.Recipient()))
 			qmlog.Errorx("permanent failure delivering from queue", err)
+			messageEvent(qmlog, rm, admindb.EventFailed, remoteMTA, eventResult, errmsg)
 			deliverDSNFailure(qmlog, rm, remoteMTA, secodeOpt, errmsg, smtpLines)
 
 			rmsgs[i] = rm
@@ -148,6 +156,10 @@ func failMsgsTx(qlog mlog.Log, tx *bstore.Tx, msgs []*Msg, dialedIPs map[string]
 		return
 	}
 
+	for _, m := range msgs {
+		messageEvent(qlog, *m, admindb.EventAttempt, remoteMTA, eventResult, errmsg)
+	}
+
 	if m0.Attempts == 5 {
 		// We've attempted deliveries at these intervals: 0, 7.5m, 15m, 30m, 1h, 2u.
 		// Let sender know delivery is delayed.
@@ -214,20 +226,20 @@ func failMsgsTx(qlog mlog.Log, tx *bstore.Tx, msgs []*Msg, dialedIPs map[string]
 }
 
 func deliverDSNFailure(log mlog.Log, m Msg, remoteMTA dsn.NameIP, secodeOpt, errmsg string, smtpLines []string) {
-	const subject = "mail delivery failed"
-	message := fmt.Sprintf(`
-Delivery has failed permanently for your email to:
+	lang := dsnLanguage(log, m)
+	subject := i18n.Translate(lang, "mail delivery failed")
+	message := "\n" + i18n.Translate(lang, `Delivery has failed permanently for your email to:
 
-	%s
+	{0}
 
 No further deliveries will be attempted.
 
 Error during the last delivery attempt:
 
-	%s
+	{1}
 `, m.Recipient().XString(m.SMTPUTF8), errmsg)
 	if len(smtpLines) > 0 {
-		message += "\nFull SMTP response:\n\n\t" + strings.Join(smtpLines, "\n\t") + "\n"
+		message += "\n" + i18n.Translate(lang, "Full SMTP response:") + "\n\n\t" + strings.Join(smtpLines, "\n\t") + "\n"
 	}
 
 	deliverDSN(log, m, remoteMTA, secodeOpt, errmsg, smtpLines, true, nil, subject, message)
@@ -240,26 +252,49 @@ func deliverDSNDelay(log mlog.Log, m Msg, remoteMTA dsn.NameIP, secodeOpt, errms
 		return
 	}
 
-	const subject = "mail delivery delayed"
-	message := fmt.Sprintf(`
-Delivery has been delayed of your email to:
+	lang := dsnLanguage(log, m)
+	subject := i18n.Translate(lang, "mail delivery delayed")
+	message := "\n" + i18n.Translate(lang, `Delivery has been delayed of your email to:
 
-	%s
+	{0}
 
 Next attempts to deliver: in 4 hours, 8 hours and 16 hours.
 If these attempts all fail, you will receive a notice.
 
 Error during the last delivery attempt:
 
-	%s
+	{1}
 `, m.Recipient().XString(false), errmsg)
 	if len(smtpLines) > 0 {
-		message += "\nFull SMTP response:\n\n\t" + strings.Join(smtpLines, "\n\t") + "\n"
+		message += "\n" + i18n.Translate(lang, "Full SMTP response:") + "\n\n\t" + strings.Join(smtpLines, "\n\t") + "\n"
 	}
 
 	deliverDSN(log, m, remoteMTA, secodeOpt, errmsg, smtpLines, false, &retryUntil, subject, message)
 }
 
+// dsnLanguage returns the language for the human-readable text of a DSN to the
+// sender of m, as configured in the webmail settings of the sender account.
+func dsnLanguage(log mlog.Log, m Msg) string {
+	if m.IsDMARCReport || m.SenderAccount == "" {
+		return ""
+	}
+	acc, err := store.OpenAccount(log, m.SenderAccount)
+	if err != nil {
+		// Error is logged when delivering the dsn.
+		return ""
+	}
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account after getting dsn language")
+	}()
+	settings := store.Settings{ID: 1}
+	if err := acc.DB.Get(context.Background(), &settings); err != nil {
+		log.Debugx("get account settings for dsn language", err)
+		return ""
+	}
+	return settings.Language
+}
+
 // We only queue DSNs for delivery failures for emails submitted by authenticated
 // users. So we are delivering to local users. ../rfc/5321:1466
 // ../rfc/5321:1494
@@ -274,6 +309,14 @@ func deliverDSN(log mlog.Log, m Msg, remoteMTA dsn.NameIP, secodeOpt, errmsg str
 		log.Errorx("queue dsn: "+text+": sender will not be informed about dsn", err, slog.String("sender", m.Sender().XString(m.SMTPUTF8)), slog.String("kind", kind))
 	}
 
+	// Messages forwarded by a frontend node to the storage node are from remote
+	// senders, and accounts on a frontend node are not used, so there is no one to
+	// inform.
+	if n := mox.Conf.Static.Node; m.XClientAddr != "" && n != nil && n.Role == "frontend" {
+		log.Error("queue dsn: not sending dsn for message forwarded to storage node", slog.String("sender", m.Sender().XString(m.SMTPUTF8)), slog.String("kind", kind), slog.String("error", errmsg))
+		return
+	}
+
 	msgf, err := os.Open(m.MessagePath())
 	if err != nil {
 		qlog("opening queued message", err)
@@ -382,10 +425,10 @@ func deliverDSN(log mlog.Log, m Msg, remoteMTA dsn.NameIP, secodeOpt, errmsg str
 	}
 
 	msg := store.Message{
-		Received:  time.Now(),
-		Size:      msgWriter.Size,
-		MsgPrefix: []byte{},
-		DSN:       true,
+		Received: time.Now(),
+		Size:     msgWriter.Size,
+		DSN:      true,
+		Sealed:   store.MessageSealed{MsgPrefix: []byte{}},
 	}
 
 	// If this is a DMARC report, deliver it as seen message to a submailbox of the
@@ -646,13 +646,13 @@ func Incoming(ctx context.Context, log mlog.Log, acc *store.Account, messageID s
 	// If this is a DSN for a message we sent, don't deliver a hook for incoming
 	// message, but an outgoing status webhook.
 	var fromID string
-	dom, err := dns.ParseDomain(m.RcptToDomain)
+	dom, err := dns.ParseDomain(m.Sealed.RcptToDomain)
 	if err != nil {
 		log.Debugx("parsing recipient domain in incoming message", err)
 	} else {
 		domconf, _ := mox.Conf.Domain(dom)
 		if domconf.LocalpartCatchallSeparator != "" {
-			t := strings.SplitN(string(m.RcptToLocalpart), domconf.LocalpartCatchallSeparator, 2)
+			t := strings.SplitN(string(m.Sealed.RcptToLocalpart), domconf.LocalpartCatchallSeparator, 2)
 			if len(t) == 2 {
 				fromID = t[1]
 			}
@@ -800,14 +800,14 @@ func Incoming(ctx context.Context, log mlog.Log, acc *store.Account, messageID s
 
 		isIncoming = true
 		var rcptTo string
-		if m.RcptToDomain != "" {
-			rcptTo = m.RcptToLocalpart.String() + "@" + m.RcptToDomain
+		if m.Sealed.RcptToDomain != "" {
+			rcptTo = m.Sealed.RcptToLocalpart.String() + "@" + m.Sealed.RcptToDomain
 		}
 		in := webhook.Incoming{
 			Structure: webhook.PartStructure(&part),
 			Meta: webhook.IncomingMeta{
 				MsgID:               m.ID,
-				MailFrom:            m.MailFrom,
+				MailFrom:            m.Sealed.MailFrom,
 				MailFromValidated:   m.MailFromValidated,
 				MsgFromValidated:    m.MsgFromValidated,
 				RcptTo:              rcptTo,
@@ -855,7 +855,7 @@ func Incoming(ctx context.Context, log mlog.Log, acc *store.Account, messageID s
 			// of service message. Several headers indicate out-of-office replies, messages
 			// from mailing or marketing lists. And the content-type can indicate a report
 			// (e.g. DSN/MDN).
-			in.Meta.Automated = m.MailFrom == "" || isAutomated(h) || part.MediaType == "MULTIPART" && part.MediaSubType == "REPORT"
+			in.Meta.Automated = m.Sealed.MailFrom == "" || isAutomated(h) || part.MediaType == "MULTIPART" && part.MediaSubType == "REPORT"
 		}
 
 		text, html, _, err := webops.ReadableParts(part, 1*1024*1024)
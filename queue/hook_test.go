@@ -45,12 +45,7 @@ func TestHookIncoming(t *testing.T) {
 		m := store.Message{
 			ID:                 123,
 			RemoteIP:           "::1",
-			MailFrom:           "sender@remote.example",
-			MailFromLocalpart:  "sender",
 			MailFromDomain:     "remote.example",
-			RcptToLocalpart:    "rcpt",
-			RcptToDomain:       "mox.example",
-			MsgFromLocalpart:   "mjl",
 			MsgFromDomain:      "mox.example",
 			MsgFromOrgDomain:   "mox.example",
 			EHLOValidated:      true,
@@ -62,6 +57,13 @@ func TestHookIncoming(t *testing.T) {
 			DKIMDomains:        []string{"remote.example"},
 			Received:           now,
 			Size:               int64(len(testmsg)),
+			Sealed: store.MessageSealed{
+				MailFrom:          "sender@remote.example",
+				MailFromLocalpart: "sender",
+				RcptToLocalpart:   "rcpt",
+				RcptToDomain:      "mox.example",
+				MsgFromLocalpart:  "mjl",
+			},
 		}
 		part, err := message.EnsurePart(pkglog.Logger, true, mr, int64(len(testmsg)))
 		tcheck(t, err, "parsing message")
@@ -97,7 +99,7 @@ func TestHookIncoming(t *testing.T) {
 			Structure: webhook.PartStructure(&part),
 			Meta: webhook.IncomingMeta{
 				MsgID:               m.ID,
-				MailFrom:            m.MailFrom,
+				MailFrom:            m.Sealed.MailFrom,
 				MailFromValidated:   m.MailFromValidated,
 				MsgFromValidated:    m.MsgFromValidated,
 				RcptTo:              "rcpt@mox.example",
@@ -145,12 +147,7 @@ func TestFromIDIncomingDelivery(t *testing.T) {
 	m := store.Message{
 		ID:                 123,
 		RemoteIP:           "::1",
-		MailFrom:           "sender@remote.example",
-		MailFromLocalpart:  "sender",
 		MailFromDomain:     "remote.example",
-		RcptToLocalpart:    "rcpt",
-		RcptToDomain:       "mox.example",
-		MsgFromLocalpart:   "mjl",
 		MsgFromDomain:      "mox.example",
 		MsgFromOrgDomain:   "mox.example",
 		EHLOValidated:      true,
@@ -162,6 +159,13 @@ func TestFromIDIncomingDelivery(t *testing.T) {
 		DKIMDomains:        []string{"remote.example"},
 		Received:           now,
 		DSN:                true,
+		Sealed: store.MessageSealed{
+			MailFrom:          "sender@remote.example",
+			MailFromLocalpart: "sender",
+			RcptToLocalpart:   "rcpt",
+			RcptToDomain:      "mox.example",
+			MsgFromLocalpart:  "mjl",
+		},
 	}
 
 	testIncoming := func(a *store.Account, rawmsg []byte, retiredFromID string, expIn bool, expOut *webhook.Outgoing) {
@@ -183,10 +187,10 @@ func TestFromIDIncomingDelivery(t *testing.T) {
 			Success:            true,
 			KeepUntil:          now.Add(time.Minute),
 		}
-		m.RcptToLocalpart = "mjl"
+		m.Sealed.RcptToLocalpart = "mjl"
 		qmr.FromID = retiredFromID
 		m.Size = int64(len(rawmsg))
-		m.RcptToLocalpart += smtp.Localpart("+unique")
+		m.Sealed.RcptToLocalpart += smtp.Localpart("+unique")
 
 		err = DB.Insert(ctxbg, &qmr)
 		tcheck(t, err, "insert retired message to match")
@@ -496,9 +500,9 @@ func TestFromIDIncomingDelivery(t *testing.T) {
 	}
 	err = DB.Insert(ctxbg, &qmr)
 	tcheck(t, err, "insert retired message to match")
-	m.RcptToLocalpart = "mjl"
+	m.Sealed.RcptToLocalpart = "mjl"
 	m.Size = int64(len(msgdelayed))
-	m.RcptToLocalpart += smtp.Localpart("+unique")
+	m.Sealed.RcptToLocalpart += smtp.Localpart("+unique")
 
 	mr := bytes.NewReader(msgdelayed)
 	part, err := message.EnsurePart(pkglog.Logger, true, mr, int64(len(msgdelayed)))
@@ -0,0 +1,94 @@
+package queue
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+)
+
+var (
+	metricQueueMessages = prometheus.NewDesc(
+		"mox_queue_messages",
+		"Messages in the queue.",
+		nil, nil,
+	)
+	metricQueueDomainMessages = prometheus.NewDesc(
+		"mox_queue_domain_messages",
+		"Messages in the queue per destination domain, for the domains with the most messages, see MetricsSeries in mox.conf. Messages for other domains are counted under domain \"other\".",
+		[]string{"domain"}, nil,
+	)
+)
+
+func init() {
+	prometheus.MustRegister(queueCollector{})
+}
+
+// queueCollector gathers the number of messages in the queue when metrics are
+// requested. The number of per-domain series is limited by the configuration.
+type queueCollector struct{}
+
+func (queueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- metricQueueMessages
+	ch <- metricQueueDomainMessages
+}
+
+func (queueCollector) Collect(ch chan<- prometheus.Metric) {
+	if DB == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(mox.Shutdown, 10*time.Second)
+	defer cancel()
+
+	counts := map[string]int{}
+	var total int
+	err := bstore.QueryDB[Msg](ctx, DB).ForEach(func(m Msg) error {
+		counts[m.RecipientDomainStr]++
+		total++
+		return nil
+	})
+	if err != nil {
+		mlog.New("queue", nil).Errorx("counting messages in queue for metrics", err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(metricQueueMessages, prometheus.GaugeValue, float64(total))
+
+	ms := mox.Conf.Static.MetricsSeries
+	if ms == nil {
+		return
+	}
+	type domainCount struct {
+		domain string
+		count  int
+	}
+	var l []domainCount
+	for d, n := range counts {
+		l = append(l, domainCount{d, n})
+	}
+	slices.SortFunc(l, func(a, b domainCount) int {
+		if a.count != b.count {
+			return b.count - a.count
+		}
+		if a.domain < b.domain {
+			return -1
+		}
+		return 1
+	})
+	var other int
+	for i, dc := range l {
+		// A domain "other" would clash with the series for the remaining domains.
+		if i >= ms.QueueDomains || dc.domain == "other" {
+			other += dc.count
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(metricQueueDomainMessages, prometheus.GaugeValue, float64(dc.count), dc.domain)
+	}
+	ch <- prometheus.MustNewConstMetric(metricQueueDomainMessages, prometheus.GaugeValue, float64(other), "other")
+}
@@ -0,0 +1,74 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/webapi"
+)
+
+// AccountPurged has the number of records removed by AccountPurge.
+type AccountPurged struct {
+	Messages        int
+	RetiredMessages int
+	Hooks           int
+	RetiredHooks    int
+	Suppressions    int
+	HoldRules       int
+}
+
+// AccountPurge removes all queue data referencing an account that is being
+// deleted: messages not yet delivered, retired messages, pending and retired
+// webhooks, suppressions and hold rules. Unlike Drop, no DSNs are sent, no
+// webhooks are queued and nothing is kept as retired.
+func AccountPurge(ctx context.Context, log mlog.Log, account string) (purged AccountPurged, rerr error) {
+	var msgs []Msg
+	err := DB.Write(ctx, func(tx *bstore.Tx) error {
+		q := bstore.QueryTx[Msg](tx)
+		q.FilterNonzero(Msg{SenderAccount: account})
+		q.Gather(&msgs)
+		var err error
+		if purged.Messages, err = q.Delete(); err != nil {
+			return fmt.Errorf("removing messages: %v", err)
+		}
+		if purged.RetiredMessages, err = bstore.QueryTx[MsgRetired](tx).FilterNonzero(MsgRetired{SenderAccount: account}).Delete(); err != nil {
+			return fmt.Errorf("removing retired messages: %v", err)
+		}
+		if purged.Hooks, err = bstore.QueryTx[Hook](tx).FilterNonzero(Hook{Account: account}).Delete(); err != nil {
+			return fmt.Errorf("removing webhooks: %v", err)
+		}
+		if purged.RetiredHooks, err = bstore.QueryTx[HookRetired](tx).FilterNonzero(HookRetired{Account: account}).Delete(); err != nil {
+			return fmt.Errorf("removing retired webhooks: %v", err)
+		}
+		if purged.Suppressions, err = bstore.QueryTx[webapi.Suppression](tx).FilterNonzero(webapi.Suppression{Account: account}).Delete(); err != nil {
+			return fmt.Errorf("removing suppressions: %v", err)
+		}
+		if purged.HoldRules, err = bstore.QueryTx[HoldRule](tx).FilterNonzero(HoldRule{Account: account}).Delete(); err != nil {
+			return fmt.Errorf("removing hold rules: %v", err)
+		}
+		return metricHoldUpdate(tx)
+	})
+	if err != nil {
+		return AccountPurged{}, err
+	}
+	if len(msgs) > 0 {
+		if err := removeMsgsFS(log, msgs...); err != nil {
+			return purged, err
+		}
+	}
+	log.Info("purged account from queue",
+		slog.String("account", account),
+		slog.Int("messages", purged.Messages),
+		slog.Int("retiredmessages", purged.RetiredMessages),
+		slog.Int("hooks", purged.Hooks),
+		slog.Int("retiredhooks", purged.RetiredHooks),
+		slog.Int("suppressions", purged.Suppressions),
+		slog.Int("holdrules", purged.HoldRules))
+	kick()
+	hookqueueKick()
+	return purged, nil
+}
@@ -16,6 +16,7 @@ import (
 	"runtime/debug"
 	"slices"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/net/proxy"
@@ -25,6 +26,7 @@ import (
 
 	"github.com/mjl-/bstore"
 
+	"github.com/mjl-/mox/admindb"
 	"github.com/mjl-/mox/config"
 	"github.com/mjl-/mox/dns"
 	"github.com/mjl-/mox/dsn"
@@ -37,6 +39,7 @@ import (
 	"github.com/mjl-/mox/store"
 	"github.com/mjl-/mox/tlsrpt"
 	"github.com/mjl-/mox/tlsrptdb"
+	"github.com/mjl-/mox/tracing"
 	"github.com/mjl-/mox/webapi"
 	"github.com/mjl-/mox/webhook"
 )
@@ -70,10 +73,23 @@ var (
 			Help: "Messages in queue that are on hold.",
 		},
 	)
+	metricLatency = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "mox_queue_latency_seconds",
+			Help:    "Time from queueing a message until it was removed from the queue, e.g. after delivery.",
+			Buckets: []float64{1, 5, 30, 60, 300, 900, 3600, 4 * 3600, 24 * 3600, 3 * 24 * 3600, 7 * 24 * 3600},
+		},
+		[]string{
+			"result", // delivered, failed, relayed, expanded, canceled, suppressed
+		},
+	)
 )
 
 var jitter = mox.NewPseudoRand()
 
+// ErrUndoExpired is returned by Undo when delivery can no longer be canceled.
+var ErrUndoExpired = errors.New("delivery can no longer be canceled")
+
 var DBTypes = []any{Msg{}, HoldRule{}, MsgRetired{}, webapi.Suppression{}, Hook{}, HookRetired{}} // Types stored in DB.
 var DB *bstore.DB                                                                                 // Exported for making backups.
 
@@ -120,6 +136,7 @@ type Msg struct {
 
 	Queued             time.Time      `bstore:"default now"`
 	Hold               bool           // If set, delivery won't be attempted.
+	HoldUntil          time.Time      // If set, delivery won't be attempted before this time, and the sender can cancel the message until then, for "undo send".
 	SenderAccount      string         // Failures are delivered back to this local account. Also used for routing.
 	SenderLocalpart    smtp.Localpart // Should be a local user and domain.
 	SenderDomain       dns.IPDomain
@@ -176,6 +193,17 @@ type Msg struct {
 	// ../rfc/4865:305
 
 	Extra map[string]string // Extra information, for transactional email.
+
+	// W3C traceparent of the span that queued the message, e.g. the incoming SMTP
+	// transaction. Delivery attempts continue this trace, if tracing is enabled.
+	TraceParent string
+
+	// For messages received by a frontend node or secondary MX and forwarded to the
+	// storage node or primary, the remote IP and EHLO hostname of the original SMTP
+	// connection, passed on with XCLIENT. A frontend node does not send DSNs for
+	// failed deliveries of forwarded messages, its accounts are not used.
+	XClientAddr string
+	XClientHelo string
 }
 
 // MsgResult is the result (or work in progress) of a delivery attempt.
@@ -627,10 +655,15 @@ func Add(ctx context.Context, log mlog.Log, senderAccount string, msgFile *os.Fi
 
 	base := true
 
+	traceParent := tracing.TraceParent(ctx)
+
 	for i, qm := range qml {
 		if qm.ID != 0 {
 			return fmt.Errorf("id of queued messages must be 0")
 		}
+		if qm.TraceParent == "" {
+			qml[i].TraceParent = traceParent
+		}
 		// Sanity check, internal consistency.
 		qml[i].SenderDomainStr = formatIPDomain(qm.SenderDomain)
 		qml[i].RecipientDomainStr = formatIPDomain(qm.RecipientDomain)
@@ -717,11 +750,40 @@ func Add(ctx context.Context, log mlog.Log, senderAccount string, msgFile *os.Fi
 	tx = nil
 	paths = nil
 
+	for _, qm := range qml {
+		detail := "from " + qm.Sender().XString(true)
+		if qm.Transport != "" {
+			detail += ", transport " + qm.Transport
+		}
+		if qm.Hold {
+			detail += ", on hold"
+		}
+		messageEvent(log, qm, admindb.EventQueued, dsn.NameIP{}, "", detail)
+	}
+
 	msgqueueKick()
 
 	return nil
 }
 
+// messageEvent records a step in the delivery history of a queued message.
+func messageEvent(log mlog.Log, m Msg, kind admindb.EventKind, remoteMTA dsn.NameIP, result, detail string) {
+	remote := remoteMTA.Name
+	if remoteMTA.IP != nil {
+		remote = strings.TrimSpace(remote + " " + remoteMTA.IP.String())
+	}
+	admindb.MessageEventAdd(context.Background(), log, admindb.MessageEvent{
+		MessageID: m.MessageID,
+		QueueID:   m.ID,
+		Kind:      kind,
+		Account:   m.SenderAccount,
+		Recipient: m.Recipient().XString(true),
+		Remote:    remote,
+		Result:    result,
+		Detail:    detail,
+	})
+}
+
 func formatIPDomain(d dns.IPDomain) string {
 	if len(d.IP) > 0 {
 		return "[" + d.IP.String() + "]"
@@ -731,9 +793,28 @@ func formatIPDomain(d dns.IPDomain) string {
 
 var (
 	msgqueue        = make(chan struct{}, 1)
-	deliveryResults = make(chan string, 1)
+	deliveryResults = make(chan deliveryDone, 1)
+	workerPing      = make(chan chan struct{})
+	workerStarted   atomic.Bool
 )
 
+// WorkerRunning returns an error if the goroutine delivering messages from the
+// queue does not respond before ctx is done, e.g. because it isn't running or is
+// stuck. For health checks.
+func WorkerRunning(ctx context.Context) error {
+	if !workerStarted.Load() {
+		return fmt.Errorf("queue worker not started")
+	}
+	c := make(chan struct{})
+	select {
+	case workerPing <- c:
+	case <-ctx.Done():
+		return fmt.Errorf("queue worker not responding: %w", ctx.Err())
+	}
+	<-c
+	return nil
+}
+
 func kick() {
 	msgqueueKick()
 	hookqueueKick()
@@ -886,6 +967,13 @@ func failDrop(ctx context.Context, log mlog.Log, filter Filter, fail bool) (affe
 	if err != nil {
 		return 0, err
 	}
+	result := "canceled"
+	if fail {
+		result = "failed"
+	}
+	for _, m := range msgs {
+		messageEvent(log, m, admindb.EventFailed, dsn.NameIP{}, result, "delivery canceled by admin")
+	}
 	if len(msgs) > 0 {
 		if err := removeMsgsFS(log, msgs...); err != nil {
 			return len(msgs), fmt.Errorf("removing queue messages from file system: %w", err)
@@ -895,6 +983,51 @@ func failDrop(ctx context.Context, log mlog.Log, filter Filter, fail bool) (affe
 	return len(msgs), nil
 }
 
+// Undo cancels delivery of messages of senderAccount, for "undo send". Messages
+// must have been added with a HoldUntil in the future that hasn't passed yet, and
+// no delivery attempt must have been made. Either all messages are canceled, or
+// none and ErrUndoExpired is returned.
+func Undo(ctx context.Context, log mlog.Log, senderAccount string, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	var msgs []Msg
+	err := DB.Write(ctx, func(tx *bstore.Tx) error {
+		q := bstore.QueryTx[Msg](tx)
+		q.FilterIDs(ids)
+		q.FilterNonzero(Msg{SenderAccount: senderAccount})
+		var err error
+		msgs, err = q.List()
+		if err != nil {
+			return fmt.Errorf("getting messages to cancel: %v", err)
+		}
+		now := time.Now()
+		if len(msgs) != len(ids) {
+			return ErrUndoExpired
+		}
+		for i := range msgs {
+			// Attempts is incremented in the same transaction that starts a delivery, so this
+			// also guards against a delivery that is about to start.
+			if msgs[i].Attempts > 0 || msgs[i].HoldUntil.IsZero() || !now.Before(msgs[i].HoldUntil) {
+				return ErrUndoExpired
+			}
+			msgs[i].Results = append(msgs[i].Results, MsgResult{Start: now, Error: "delivery canceled by sender"})
+		}
+		if err := retireMsgs(log, tx, webhook.EventCanceled, 0, "", nil, msgs...); err != nil {
+			return fmt.Errorf("removing queue messages from database: %w", err)
+		}
+		return metricHoldUpdate(tx)
+	})
+	if err != nil {
+		return err
+	}
+	if err := removeMsgsFS(log, msgs...); err != nil {
+		return fmt.Errorf("removing queue messages from file system: %w", err)
+	}
+	kick()
+	return nil
+}
+
 // RequireTLSSet updates the RequireTLS field of matching messages.
 func RequireTLSSet(ctx context.Context, filter Filter, requireTLS *bool) (affected int, err error) {
 	q := bstore.QueryDB[Msg](ctx, DB)
@@ -1069,7 +1202,6 @@ func OpenMessage(ctx context.Context, id int64) (ReadReaderAtCloser, error) {
 	return r, err
 }
 
-const maxConcurrentDeliveries = 10
 const maxConcurrentHookDeliveries = 10
 
 // Start opens the database by calling Init, then starts the delivery and cleanup
@@ -1079,6 +1211,7 @@ func Start(resolver dns.Resolver, done chan struct{}) error {
 		return err
 	}
 
+	workerStarted.Store(true)
 	go startQueue(resolver, done)
 	go startHookQueue(done)
 
@@ -1126,11 +1259,12 @@ func startQueue(resolver dns.Resolver, done chan struct{}) {
 	// High-level delivery strategy advice: ../rfc/5321:3685
 	log := mlog.New("queue", nil)
 
-	// Map keys are either dns.Domain.Name()'s, or string-formatted IP addresses.
-	busyDomains := map[string]struct{}{}
+	busy := newInflight()
 
 	timer := time.NewTimer(0)
 
+	defer workerStarted.Store(false)
+
 	for {
 		select {
 		case <-mox.Shutdown.Done():
@@ -1138,75 +1272,20 @@ func startQueue(resolver dns.Resolver, done chan struct{}) {
 			return
 		case <-msgqueue:
 		case <-timer.C:
-		case domain := <-deliveryResults:
-			delete(busyDomains, domain)
-		}
-
-		if len(busyDomains) >= maxConcurrentDeliveries {
+		case r := <-deliveryResults:
+			busy.remove(r)
+		case c := <-workerPing:
+			close(c)
 			continue
 		}
 
-		launchWork(log, resolver, busyDomains)
-		timer.Reset(nextWork(mox.Shutdown, log, busyDomains))
-	}
-}
-
-func nextWork(ctx context.Context, log mlog.Log, busyDomains map[string]struct{}) time.Duration {
-	q := bstore.QueryDB[Msg](ctx, DB)
-	if len(busyDomains) > 0 {
-		var doms []any
-		for d := range busyDomains {
-			doms = append(doms, d)
-		}
-		q.FilterNotEqual("RecipientDomainStr", doms...)
-	}
-	q.FilterEqual("Hold", false)
-	q.SortAsc("NextAttempt")
-	q.Limit(1)
-	qm, err := q.Get()
-	if err == bstore.ErrAbsent {
-		return 24 * time.Hour
-	} else if err != nil {
-		log.Errorx("finding time for next delivery attempt", err)
-		return 1 * time.Minute
-	}
-	return time.Until(qm.NextAttempt)
-}
-
-func launchWork(log mlog.Log, resolver dns.Resolver, busyDomains map[string]struct{}) int {
-	q := bstore.QueryDB[Msg](mox.Shutdown, DB)
-	q.FilterLessEqual("NextAttempt", time.Now())
-	q.FilterEqual("Hold", false)
-	q.SortAsc("NextAttempt")
-	q.Limit(maxConcurrentDeliveries)
-	if len(busyDomains) > 0 {
-		var doms []any
-		for d := range busyDomains {
-			doms = append(doms, d)
-		}
-		q.FilterNotEqual("RecipientDomainStr", doms...)
-	}
-	var msgs []Msg
-	seen := map[string]bool{}
-	err := q.ForEach(func(m Msg) error {
-		dom := m.RecipientDomainStr
-		if _, ok := busyDomains[dom]; !ok && !seen[dom] {
-			seen[dom] = true
-			msgs = append(msgs, m)
+		if busy.n >= deliveryLimits().workers {
+			continue
 		}
-		return nil
-	})
-	if err != nil {
-		log.Errorx("querying for work in queue", err)
-		mox.Sleep(mox.Shutdown, 1*time.Second)
-		return -1
-	}
 
-	for _, m := range msgs {
-		busyDomains[m.RecipientDomainStr] = struct{}{}
-		go deliver(log, resolver, m)
+		launchWork(log, resolver, busy)
+		timer.Reset(nextWork(mox.Shutdown, log, busy))
 	}
-	return len(msgs)
 }
 
 // todo future: we may consider keeping message files around for a while after retiring. especially for failures to deliver. to inspect what exactly wasn't delivered.
@@ -1266,6 +1345,7 @@ func retireMsgs(log mlog.Log, tx *bstore.Tx, event webhook.OutgoingEvent, code i
 		if err := tx.Delete(&m); err != nil {
 			return err
 		}
+		metricLatency.WithLabelValues(string(event)).Observe(float64(now.Sub(m.Queued)) / float64(time.Second))
 	}
 	if msgKeep > 0 {
 		for _, m := range msgs {
@@ -1301,8 +1381,10 @@ func deliver(log mlog.Log, resolver dns.Resolver, m0 Msg) {
 		slog.Any("from", m0.Sender()),
 		slog.Int("attempts", m0.Attempts))
 
+	result := deliveryDone{m0.RecipientDomainStr, m0.ID}
+	attempts := m0.Attempts
 	defer func() {
-		deliveryResults <- formatIPDomain(m0.RecipientDomain)
+		deliveryResults <- result
 
 		x := recover()
 		if x != nil {
@@ -1341,7 +1423,12 @@ func deliver(log mlog.Log, resolver dns.Resolver, m0 Msg) {
 		// Refresh message within transaction.
 		m0 = Msg{ID: m0.ID}
 		if err := xtx.Get(&m0); err != nil {
-			return fmt.Errorf("get message to be delivered: %v", err)
+			return fmt.Errorf("get message to be delivered: %w", err)
+		}
+		if m0.Attempts != attempts {
+			// Attempted by a concurrent delivery to the same domain, that gathered this
+			// message as additional recipient.
+			return errAttempted
 		}
 
 		backoff = time.Duration(7*60+30+jitter.Intn(10)-5) * time.Second
@@ -1358,11 +1445,27 @@ func deliver(log mlog.Log, resolver dns.Resolver, m0 Msg) {
 		}
 		return nil
 	}
-	if err := prepare(); err != nil {
+	if err := prepare(); errors.Is(err, bstore.ErrAbsent) {
+		// E.g. canceled by the sender with "undo send" just before delivery started.
+		qlog.Debug("message removed from queue before delivery", slog.Int64("msgid", m0.ID))
+		return
+	} else if err == errAttempted {
+		qlog.Debug("message already attempted by concurrent delivery", slog.Int64("msgid", m0.ID))
+		return
+	} else if err != nil {
 		qlog.Errorx("storing delivery attempt", err, slog.Int64("msgid", m0.ID), slog.Any("recipient", m0.Recipient()))
 		return
 	}
 
+	// The delivery attempt continues the trace of the transaction that queued the
+	// message.
+	ctx, span := tracing.StartKind(tracing.ContextWithParent(ctx, m0.TraceParent), "queue.deliver", tracing.KindInternal,
+		tracing.Int64("queue.msgid", m0.ID),
+		tracing.Int("queue.attempt", m0.Attempts),
+		tracing.String("queue.recipientdomain", m0.RecipientDomainStr),
+	)
+	defer span.End()
+
 	var remoteMTA dsn.NameIP // Zero value, will not be included in DSN. ../rfc/3464:1027
 
 	// Check if recipient is on suppression list. If so, fail delivery.
@@ -1571,12 +1674,12 @@ func deliver(log mlog.Log, resolver dns.Resolver, m0 Msg) {
 
 	var dialer smtpclient.Dialer = &net.Dialer{}
 	if transport.Submissions != nil {
-		deliverSubmit(qlog, resolver, dialer, msgs, backoff, transportName, transport.Submissions, true, 465)
+		deliverSubmit(ctx, qlog, resolver, dialer, msgs, backoff, transportName, transport.Submissions, true, 465)
 	} else if transport.Submission != nil {
-		deliverSubmit(qlog, resolver, dialer, msgs, backoff, transportName, transport.Submission, false, 587)
+		deliverSubmit(ctx, qlog, resolver, dialer, msgs, backoff, transportName, transport.Submission, false, 587)
 	} else if transport.SMTP != nil {
 		// todo future: perhaps also gather tlsrpt results for submissions.
-		deliverSubmit(qlog, resolver, dialer, msgs, backoff, transportName, transport.SMTP, false, 25)
+		deliverSubmit(ctx, qlog, resolver, dialer, msgs, backoff, transportName, transport.SMTP, false, 25)
 	} else {
 		ourHostname := mox.Conf.Static.HostnameDomain
 		if transport.Socks != nil {
@@ -1592,7 +1695,7 @@ func deliver(log mlog.Log, resolver dns.Resolver, m0 Msg) {
 			}
 			ourHostname = transport.Socks.Hostname
 		}
-		recipientDomainResult, hostResults = deliverDirect(qlog, resolver, dialer, ourHostname, transportName, transport.Direct, msgs, backoff)
+		recipientDomainResult, hostResults = deliverDirect(ctx, qlog, resolver, dialer, ourHostname, transportName, transport.Direct, msgs, backoff)
 	}
 }
 
@@ -9,10 +9,13 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math/big"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -22,9 +25,13 @@ import (
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+
 	"github.com/mjl-/adns"
 	"github.com/mjl-/bstore"
 
+	"github.com/mjl-/mox/admindb"
+	"github.com/mjl-/mox/config"
 	"github.com/mjl-/mox/dns"
 	"github.com/mjl-/mox/mlog"
 	"github.com/mjl-/mox/mox-"
@@ -33,6 +40,7 @@ import (
 	"github.com/mjl-/mox/store"
 	"github.com/mjl-/mox/tlsrpt"
 	"github.com/mjl-/mox/tlsrptdb"
+	"github.com/mjl-/mox/tracing"
 	"github.com/mjl-/mox/webhook"
 )
 
@@ -160,18 +168,31 @@ func TestQueue(t *testing.T) {
 		t.Fatalf("dropped message not removed from file system")
 	}
 
-	// Fail a message, check the account has a message afterwards, the DSN.
+	// Delivery history of the dropped message has been recorded. It includes the
+	// other messages with the same Message-ID.
+	events, err := admindb.MessageEventList(ctxbg, fmt.Sprintf("%d", msgs[1].ID))
+	tcheck(t, err, "list message events")
+	tcompare(t, len(events), 4)
+	last := events[len(events)-1]
+	tcompare(t, last.Kind, admindb.EventFailed)
+	tcompare(t, last.QueueID, msgs[1].ID)
+	tcompare(t, last.Result, "canceled")
+
+	// Fail a message, check the account has a message afterwards, the DSN, in the
+	// language of the account.
 	n, err = bstore.QueryDB[store.Message](ctxbg, acc.DB).Count()
 	tcheck(t, err, "count messages in account")
 	tcompare(t, n, 0)
+	err = acc.DB.Update(ctxbg, &store.Settings{ID: 1, Language: "nl"})
+	tcheck(t, err, "set account language")
 	n, err = Fail(ctxbg, pkglog, Filter{IDs: []int64{msgs[2].ID}})
 	tcheck(t, err, "fail")
 	if n != 1 {
 		t.Fatalf("failed %d, expected 1", n)
 	}
-	n, err = bstore.QueryDB[store.Message](ctxbg, acc.DB).Count()
-	tcheck(t, err, "count messages in account")
-	tcompare(t, n, 1)
+	dsnm, err := bstore.QueryDB[store.Message](ctxbg, acc.DB).Get()
+	tcheck(t, err, "get dsn message in account")
+	tcompare(t, dsnm.SubjectBase, "bezorging van e-mail mislukt")
 
 	// Check filter through various List calls. Other code uses the same filtering function.
 	filter := func(f Filter, expn int) {
@@ -206,7 +227,8 @@ func TestQueue(t *testing.T) {
 	if next > 0 {
 		t.Fatalf("nextWork in %s, should be now", next)
 	}
-	busy := map[string]struct{}{"mox.example": {}}
+	busy := newInflight()
+	busy.domains["mox.example"] = 1
 	if x := nextWork(ctxbg, pkglog, busy); x != 24*time.Hour {
 		t.Fatalf("nextWork in %s for busy domain, should be in 24 hours", x)
 	}
@@ -237,7 +259,7 @@ func TestQueue(t *testing.T) {
 		smtpclient.DialHook = nil
 	}()
 
-	n = launchWork(pkglog, resolver, map[string]struct{}{})
+	n = launchWork(pkglog, resolver, newInflight())
 	tcompare(t, n, 1)
 
 	// Wait until we see the dial and the failed attempt.
@@ -507,7 +529,7 @@ func TestQueue(t *testing.T) {
 		inboxCount, err := bstore.QueryDB[store.Message](ctxbg, acc.DB).FilterNonzero(store.Message{MailboxID: inbox.ID}).Count()
 		tcheck(t, err, "querying messages in inbox")
 
-		launchWork(pkglog, resolver, map[string]struct{}{})
+		launchWork(pkglog, resolver, newInflight())
 
 		// Wait for all results.
 		timer.Reset(time.Second)
@@ -554,6 +576,26 @@ func TestQueue(t *testing.T) {
 		t.Fatalf("expected net.Dialer as dialer")
 	}
 
+	// Delivery history of the message: queued, the failed attempt, and delivered.
+	events, err = admindb.MessageEventList(ctxbg, fmt.Sprintf("%d", msg.ID))
+	tcheck(t, err, "list message events")
+	var kinds []admindb.EventKind
+	for _, e := range events {
+		if e.QueueID == msg.ID {
+			kinds = append(kinds, e.Kind)
+		}
+	}
+	tcompare(t, kinds, []admindb.EventKind{admindb.EventQueued, admindb.EventAttempt, admindb.EventDelivered})
+	last = events[len(events)-1]
+	tcompare(t, last.Kind, admindb.EventDelivered)
+	tcompare(t, last.Recipient, "mjl@mox.example")
+	tcompare(t, last.Remote, "mail.mox.example") // No IP for the fake connection.
+	for _, e := range events {
+		if e.QueueID == msg.ID && e.Kind == admindb.EventAttempt && !strings.Contains(e.Detail, "failure from test") {
+			t.Fatalf("attempt event detail %q, expected dial error", e.Detail)
+		}
+	}
+
 	// Single delivery to two recipients at same domain, expecting single connection
 	// and single transaction.
 	qm0 := MakeMsg(path, path, false, false, int64(len(testmsg)), "<test@localhost>", nil, nil, time.Now(), "test")
@@ -1055,7 +1097,7 @@ func TestRetiredHooks(t *testing.T) {
 			}()
 
 			// Trigger delivery attempt.
-			n := launchWork(pkglog, resolver, map[string]struct{}{})
+			n := launchWork(pkglog, resolver, newInflight())
 			tcompare(t, n, 1)
 
 			// Wait until delivery has finished.
@@ -1132,7 +1174,7 @@ func TestRetiredHooks(t *testing.T) {
 	testAction("retired", makeLaunchAction(smtpReject(550)), &MsgResult{Code: 550, Secode: "1.0", Error: "nonempty"}, string(webhook.EventFailed), true)
 	// Try to deliver to suppressed addresses.
 	launch := func() {
-		n := launchWork(pkglog, resolver, map[string]struct{}{})
+		n := launchWork(pkglog, resolver, newInflight())
 		tcompare(t, n, 1)
 		<-deliveryResults
 	}
@@ -1487,3 +1529,131 @@ func fakeCert(t *testing.T, name string, expired bool) tls.Certificate {
 	}
 	return c
 }
+
+func TestUndo(t *testing.T) {
+	_, cleanup := setup(t)
+	defer cleanup()
+	err := Init()
+	tcheck(t, err, "queue init")
+
+	path := smtp.Path{Localpart: "mjl", IPDomain: dns.IPDomain{Domain: dns.Domain{ASCII: "mox.example"}}}
+	mf := prepareFile(t)
+	defer os.Remove(mf.Name())
+	defer mf.Close()
+
+	add := func(holdUntil time.Time) Msg {
+		t.Helper()
+		qm := MakeMsg(path, path, false, false, int64(len(testmsg)), "<test@localhost>", nil, nil, holdUntil, "test")
+		qm.HoldUntil = holdUntil
+		err := Add(ctxbg, pkglog, "mjl", mf, qm)
+		tcheck(t, err, "add message to queue")
+		l, err := List(ctxbg, Filter{}, Sort{Field: "Queued"})
+		tcheck(t, err, "list queue")
+		return l[0]
+	}
+
+	qm := add(time.Now().Add(time.Minute))
+	err = Undo(ctxbg, pkglog, "other", []int64{qm.ID})
+	tcompare(t, errors.Is(err, ErrUndoExpired), true) // Not our message.
+	err = Undo(ctxbg, pkglog, "mjl", []int64{qm.ID})
+	tcheck(t, err, "undo")
+	n, err := Count(ctxbg)
+	tcheck(t, err, "count")
+	tcompare(t, n, 0)
+	if _, err := os.Stat(qm.MessagePath()); err == nil || !os.IsNotExist(err) {
+		t.Fatalf("canceled message not removed from file system")
+	}
+
+	// Grace period has passed.
+	qm = add(time.Now().Add(-time.Second))
+	err = Undo(ctxbg, pkglog, "mjl", []int64{qm.ID})
+	tcompare(t, errors.Is(err, ErrUndoExpired), true)
+
+	// Delivery attempt has started.
+	qm = add(time.Now().Add(time.Minute))
+	_, err = bstore.QueryDB[Msg](ctxbg, DB).FilterIDs([]int64{qm.ID}).UpdateNonzero(Msg{Attempts: 1})
+	tcheck(t, err, "update attempts")
+	err = Undo(ctxbg, pkglog, "mjl", []int64{qm.ID})
+	tcompare(t, errors.Is(err, ErrUndoExpired), true)
+}
+
+// Test the number of queued messages per destination domain in the metrics.
+func TestMetrics(t *testing.T) {
+	_, cleanup := setup(t)
+	defer cleanup()
+	err := Init()
+	tcheck(t, err, "queue init")
+
+	mf := prepareFile(t)
+	defer os.Remove(mf.Name())
+	defer mf.Close()
+
+	from := smtp.Path{Localpart: "mjl", IPDomain: dns.IPDomain{Domain: dns.Domain{ASCII: "mox.example"}}}
+	for _, d := range []string{"a.example", "a.example", "b.example", "c.example"} {
+		to := smtp.Path{Localpart: "remote", IPDomain: dns.IPDomain{Domain: dns.Domain{ASCII: d}}}
+		qm := MakeMsg(from, to, false, false, int64(len(testmsg)), "<test@localhost>", nil, nil, time.Now(), "test")
+		err := Add(ctxbg, pkglog, "mjl", mf, qm)
+		tcheck(t, err, "add message to queue")
+	}
+
+	collect := func() map[string]float64 {
+		t.Helper()
+		reg := prometheus.NewPedanticRegistry()
+		err := reg.Register(queueCollector{})
+		tcheck(t, err, "register collector")
+		families, err := reg.Gather()
+		tcheck(t, err, "gather metrics")
+		r := map[string]float64{}
+		for _, mf := range families {
+			for _, m := range mf.GetMetric() {
+				var domain string
+				for _, lp := range m.GetLabel() {
+					domain = lp.GetValue()
+				}
+				r[domain] = m.GetGauge().GetValue()
+			}
+		}
+		return r
+	}
+
+	// Only the total without configuration.
+	tcompare(t, collect(), map[string]float64{"": 4})
+
+	mox.Conf.Static.MetricsSeries = &config.MetricsSeries{QueueDomains: 1}
+	defer func() {
+		mox.Conf.Static.MetricsSeries = nil
+	}()
+	tcompare(t, collect(), map[string]float64{"": 4, "a.example": 2, "other": 2})
+}
+
+func TestTraceParent(t *testing.T) {
+	_, cleanup := setup(t)
+	defer cleanup()
+	err := Init()
+	tcheck(t, err, "queue init")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	tracing.Configure(pkglog.Logger, tracing.Config{Endpoint: srv.URL})
+	defer tracing.Stop()
+
+	mf := prepareFile(t)
+	defer os.Remove(mf.Name())
+	defer mf.Close()
+
+	// Messages queued during a span get its traceparent, for continuing the trace
+	// during delivery.
+	ctx, span := tracing.Start(ctxbg, "test")
+	defer span.End()
+	from := smtp.Path{Localpart: "mjl", IPDomain: dns.IPDomain{Domain: dns.Domain{ASCII: "mox.example"}}}
+	to := smtp.Path{Localpart: "remote", IPDomain: dns.IPDomain{Domain: dns.Domain{ASCII: "remote.example"}}}
+	qm := MakeMsg(from, to, false, false, int64(len(testmsg)), "<test@localhost>", nil, nil, time.Now(), "test")
+	err = Add(ctx, pkglog, "mjl", mf, qm)
+	tcheck(t, err, "add message to queue")
+
+	msgs, err := List(ctxbg, Filter{}, Sort{})
+	tcheck(t, err, "list queue")
+	tcompare(t, len(msgs), 1)
+	tcompare(t, msgs[0].TraceParent, tracing.TraceParent(ctx))
+	tcompare(t, msgs[0].TraceParent != "", true)
+}
@@ -0,0 +1,176 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+)
+
+var errAttempted = errors.New("message already attempted")
+
+// limits for concurrent deliveries from the queue.
+type limits struct {
+	workers   int // Deliveries in total.
+	perDomain int // Deliveries per recipient domain.
+}
+
+// deliveryLimits returns the configured limits for concurrent deliveries.
+func deliveryLimits() limits {
+	l := limits{10, 1}
+	if qw := mox.Conf.Static.QueueWorkers; qw != nil {
+		if qw.Workers > 0 {
+			l.workers = qw.Workers
+		}
+		if qw.PerDomain > 0 {
+			l.perDomain = qw.PerDomain
+		}
+	}
+	return l
+}
+
+// deliveryDone is sent by a delivery when it is done.
+type deliveryDone struct {
+	domain string // Recipient domain, as in Msg.RecipientDomainStr.
+	msgID  int64
+}
+
+// inflight tracks deliveries in progress, for scheduling new deliveries.
+type inflight struct {
+	n       int                // Total deliveries.
+	domains map[string]int     // Deliveries per recipient domain.
+	msgIDs  map[int64]struct{} // Messages being delivered.
+}
+
+func newInflight() *inflight {
+	return &inflight{domains: map[string]int{}, msgIDs: map[int64]struct{}{}}
+}
+
+func (f *inflight) add(m Msg) {
+	f.n++
+	f.domains[m.RecipientDomainStr]++
+	f.msgIDs[m.ID] = struct{}{}
+}
+
+func (f *inflight) remove(r deliveryDone) {
+	if _, ok := f.msgIDs[r.msgID]; !ok {
+		return
+	}
+	f.n--
+	f.domains[r.domain]--
+	if f.domains[r.domain] <= 0 {
+		delete(f.domains, r.domain)
+	}
+	delete(f.msgIDs, r.msgID)
+}
+
+// filter excludes messages being delivered and messages for domains that are at
+// their limit of concurrent deliveries from q. f can be nil.
+func (f *inflight) filter(q *bstore.Query[Msg], perDomain int) {
+	if f == nil {
+		return
+	}
+	var doms []any
+	for d, n := range f.domains {
+		if n >= perDomain {
+			doms = append(doms, d)
+		}
+	}
+	if len(doms) > 0 {
+		q.FilterNotEqual("RecipientDomainStr", doms...)
+	}
+	if len(f.msgIDs) > 0 {
+		var ids []any
+		for id := range f.msgIDs {
+			ids = append(ids, id)
+		}
+		q.FilterNotEqual("ID", ids...)
+	}
+}
+
+// nextWork returns the time until the next message can be delivered.
+func nextWork(ctx context.Context, log mlog.Log, busy *inflight) time.Duration {
+	q := bstore.QueryDB[Msg](ctx, DB)
+	busy.filter(q, deliveryLimits().perDomain)
+	q.FilterEqual("Hold", false)
+	q.SortAsc("NextAttempt")
+	q.Limit(1)
+	qm, err := q.Get()
+	if err == bstore.ErrAbsent {
+		return 24 * time.Hour
+	} else if err != nil {
+		log.Errorx("finding time for next delivery attempt", err)
+		return 1 * time.Minute
+	}
+	return time.Until(qm.NextAttempt)
+}
+
+// launchWork starts deliveries for messages that are due, up to the limits of
+// concurrent deliveries, and returns the number of deliveries started.
+//
+// Recipient domains are served round-robin, in order of their longest waiting
+// message, so a domain with many deferred messages doesn't starve deliveries to
+// other domains. Deferred messages for a domain stay in the queue until a delivery
+// for the domain is done.
+func launchWork(log mlog.Log, resolver dns.Resolver, busy *inflight) int {
+	lim := deliveryLimits()
+	free := lim.workers - busy.n
+	if free <= 0 {
+		return 0
+	}
+
+	q := bstore.QueryDB[Msg](mox.Shutdown, DB)
+	q.FilterLessEqual("NextAttempt", time.Now())
+	q.FilterEqual("Hold", false)
+	busy.filter(q, lim.perDomain)
+	q.SortAsc("NextAttempt")
+
+	// Gather messages per domain, at most as many as can be started for the domain.
+	var order []string
+	candidates := map[string][]Msg{}
+	err := q.ForEach(func(m Msg) error {
+		dom := m.RecipientDomainStr
+		l, ok := candidates[dom]
+		if !ok {
+			order = append(order, dom)
+		}
+		if len(l) < lim.perDomain-busy.domains[dom] {
+			candidates[dom] = append(l, m)
+		}
+		if len(order) >= free && !ok {
+			// Enough domains to start a delivery for each.
+			return bstore.StopForEach
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errorx("querying for work in queue", err)
+		mox.Sleep(mox.Shutdown, 1*time.Second)
+		return -1
+	}
+
+	var n int
+	for round := 0; n < free; round++ {
+		var launched bool
+		for _, dom := range order {
+			l := candidates[dom]
+			if round >= len(l) || n >= free {
+				continue
+			}
+			m := l[round]
+			busy.add(m)
+			go deliver(log, resolver, m)
+			n++
+			launched = true
+		}
+		if !launched {
+			break
+		}
+	}
+	return n
+}
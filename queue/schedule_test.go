@@ -0,0 +1,179 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/smtpclient"
+)
+
+// Test that a domain with a backlog of due messages doesn't starve deliveries to
+// other domains, and the limit of concurrent deliveries per domain is respected.
+func TestScheduleDomains(t *testing.T) {
+	_, cleanup := setup(t)
+	defer cleanup()
+	err := Init()
+	tcheck(t, err, "queue init")
+
+	mox.Conf.Static.QueueWorkers = &config.QueueWorkers{Workers: 4, PerDomain: 2}
+	defer func() {
+		mox.Conf.Static.QueueWorkers = nil
+	}()
+
+	mf := prepareFile(t)
+	defer os.Remove(mf.Name())
+	defer mf.Close()
+
+	add := func(domain string, queued time.Time) {
+		t.Helper()
+		path := smtp.Path{Localpart: "mjl", IPDomain: dns.IPDomain{Domain: dns.Domain{ASCII: domain}}}
+		qm := MakeMsg(path, path, false, false, int64(len(testmsg)), "<test@localhost>", nil, nil, queued, "test")
+		err := Add(ctxbg, pkglog, "mjl", mf, qm)
+		tcheck(t, err, "add message to queue")
+	}
+
+	// Backlog for mox.example, and a single message for other.example queued after
+	// all of them.
+	const nbacklog = 6
+	for i := range nbacklog {
+		add("mox.example", time.Now().Add(time.Duration(i-10)*time.Minute))
+	}
+	add("other.example", time.Now())
+
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"mail.mox.example.":   {"127.0.0.1"},
+			"mail.other.example.": {"127.0.0.2"},
+		},
+		MX: map[string][]*net.MX{
+			"mox.example.":   {{Host: "mail.mox.example", Pref: 10}},
+			"other.example.": {{Host: "mail.other.example", Pref: 10}},
+		},
+	}
+
+	// Dials block until released by the test, so we control how many deliveries are
+	// in progress. We keep track of the concurrent dials per IP, i.e. per domain.
+	var mu sync.Mutex
+	active := map[string]int{}
+	maxActive := map[string]int{}
+	release := make(chan struct{})
+	smtpclient.DialHook = func(ctx context.Context, dialer smtpclient.Dialer, timeout time.Duration, addr string, laddr net.Addr) (net.Conn, error) {
+		ip, _, _ := net.SplitHostPort(addr)
+		mu.Lock()
+		active[ip]++
+		maxActive[ip] = max(maxActive[ip], active[ip])
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			active[ip]--
+			mu.Unlock()
+		}()
+
+		select {
+		case <-release:
+		case <-ctx.Done():
+		}
+		return nil, fmt.Errorf("failure from test")
+	}
+	defer func() {
+		smtpclient.DialHook = nil
+	}()
+
+	// Wait until each delivery in progress is in its dial.
+	waitDials := func(busy *inflight) {
+		t.Helper()
+		for range 500 {
+			mu.Lock()
+			var n int
+			for _, v := range active {
+				n += v
+			}
+			mu.Unlock()
+			if n == busy.n {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("deliveries in progress not dialing")
+	}
+
+	checkBusy := func(busy *inflight) {
+		t.Helper()
+		waitDials(busy)
+		mu.Lock()
+		defer mu.Unlock()
+		for ip, n := range active {
+			if n > 2 {
+				t.Fatalf("%d concurrent dials to %s, expected at most 2", n, ip)
+			}
+		}
+		if busy.n > 4 {
+			t.Fatalf("%d deliveries in progress, expected at most 4", busy.n)
+		}
+		for dom, n := range busy.domains {
+			if n > 2 {
+				t.Fatalf("%d deliveries in progress for domain %s, expected at most 2", n, dom)
+			}
+		}
+	}
+
+	// First round starts two deliveries for mox.example, at its limit, and one for
+	// other.example, even though more messages for mox.example have been waiting
+	// longer.
+	busy := newInflight()
+	n := launchWork(pkglog, resolver, busy)
+	tcompare(t, n, 3)
+	tcompare(t, busy.domains, map[string]int{"mox.example": 2, "other.example": 1})
+	checkBusy(busy)
+	mu.Lock()
+	tcompare(t, active, map[string]int{"127.0.0.1": 2, "127.0.0.2": 1})
+	mu.Unlock()
+
+	// Nothing more can be started for now.
+	tcompare(t, launchWork(pkglog, resolver, busy), 0)
+
+	// Finish deliveries one at a time, starting new deliveries when slots free up.
+	timer := time.NewTimer(5 * time.Second)
+	defer timer.Stop()
+	for ndone := 0; ndone < nbacklog+1; ndone++ {
+		select {
+		case release <- struct{}{}:
+		case <-timer.C:
+			t.Fatalf("no dial to release")
+		}
+		select {
+		case r := <-deliveryResults:
+			busy.remove(r)
+		case <-timer.C:
+			t.Fatalf("no delivery result")
+		}
+		launchWork(pkglog, resolver, busy)
+		checkBusy(busy)
+	}
+	tcompare(t, busy.n, 0)
+
+	mu.Lock()
+	tcompare(t, maxActive, map[string]int{"127.0.0.1": 2, "127.0.0.2": 1})
+	mu.Unlock()
+
+	// Each message had a single delivery attempt.
+	msgs, err := bstore.QueryDB[Msg](ctxbg, DB).List()
+	tcheck(t, err, "list messages")
+	tcompare(t, len(msgs), nbacklog+1)
+	for _, m := range msgs {
+		if m.Attempts != 1 {
+			t.Fatalf("message %d to %s has %d attempts, expected 1", m.ID, m.RecipientDomainStr, m.Attempts)
+		}
+	}
+}
@@ -15,6 +15,8 @@ import (
 
 	"github.com/mjl-/bstore"
 
+	"github.com/mjl-/mox/accounting"
+	"github.com/mjl-/mox/admindb"
 	"github.com/mjl-/mox/config"
 	"github.com/mjl-/mox/dns"
 	"github.com/mjl-/mox/dsn"
@@ -31,7 +33,7 @@ import (
 
 // deliver via another SMTP server, e.g. relaying to a smart host, possibly
 // with authentication (submission).
-func deliverSubmit(qlog mlog.Log, resolver dns.Resolver, dialer smtpclient.Dialer, msgs []*Msg, backoff time.Duration, transportName string, transport *config.TransportSMTP, dialTLS bool, defaultPort int) {
+func deliverSubmit(ctx context.Context, qlog mlog.Log, resolver dns.Resolver, dialer smtpclient.Dialer, msgs []*Msg, backoff time.Duration, transportName string, transport *config.TransportSMTP, dialTLS bool, defaultPort int) {
 	// todo: configurable timeouts
 
 	// For convenience, all messages share the same relevant values.
@@ -82,8 +84,6 @@ func deliverSubmit(qlog mlog.Log, resolver dns.Resolver, dialer smtpclient.Diale
 
 	// todo: for submission, understand SRV records, and even DANE.
 
-	ctx := mox.Shutdown
-
 	// If submit was done with REQUIRETLS extension for SMTP, we must verify TLS
 	// certificates. If our submission connection is not configured that way, abort.
 	requireTLS := m0.RequireTLS != nil && *m0.RequireTLS
@@ -138,7 +138,7 @@ func deliverSubmit(qlog mlog.Log, resolver dns.Resolver, dialer smtpclient.Diale
 	if transport.Auth != nil {
 		a := transport.Auth
 		auth = func(mechanisms []string, cs *tls.ConnectionState) (sasl.Client, error) {
-			var supportsscramsha1plus, supportsscramsha256plus bool
+			var supportsscramsha1plus, supportsscramsha256plus, supportsscramsha512plus bool
 			for _, mech := range a.EffectiveMechanisms {
 				if !slices.Contains(mechanisms, mech) {
 					switch mech {
@@ -146,10 +146,16 @@ func deliverSubmit(qlog mlog.Log, resolver dns.Resolver, dialer smtpclient.Diale
 						supportsscramsha1plus = cs != nil
 					case "SCRAM-SHA-256-PLUS":
 						supportsscramsha256plus = cs != nil
+					case "SCRAM-SHA-512-PLUS":
+						supportsscramsha512plus = cs != nil
 					}
 					continue
 				}
-				if mech == "SCRAM-SHA-256-PLUS" && cs != nil {
+				if mech == "SCRAM-SHA-512-PLUS" && cs != nil {
+					return sasl.NewClientSCRAMSHA512PLUS(a.Username, a.Password, *cs), nil
+				} else if mech == "SCRAM-SHA-512" {
+					return sasl.NewClientSCRAMSHA512(a.Username, a.Password, supportsscramsha512plus), nil
+				} else if mech == "SCRAM-SHA-256-PLUS" && cs != nil {
 					return sasl.NewClientSCRAMSHA256PLUS(a.Username, a.Password, *cs), nil
 				} else if mech == "SCRAM-SHA-256" {
 					return sasl.NewClientSCRAMSHA256(a.Username, a.Password, supportsscramsha256plus), nil
@@ -169,12 +175,15 @@ func deliverSubmit(qlog mlog.Log, resolver dns.Resolver, dialer smtpclient.Diale
 			return nil, nil
 		}
 	}
-	clientctx, clientcancel := context.WithTimeout(context.Background(), 60*time.Second)
+	clientctx, clientcancel := context.WithTimeout(context.WithoutCancel(ctx), 60*time.Second)
 	defer clientcancel()
 	opts := smtpclient.Opts{
 		Auth:    auth,
 		RootCAs: mox.Conf.Static.TLS.CertPool,
 	}
+	if m0.XClientAddr != "" {
+		opts.XClient = &smtpclient.XClient{Addr: net.ParseIP(m0.XClientAddr), Helo: m0.XClientHelo}
+	}
 	client, err := smtpclient.New(clientctx, qlog.Logger, conn, tlsMode, tlsPKIX, mox.Conf.Static.HostnameDomain, transport.DNSHost, opts)
 	if err != nil {
 		smtperr, ok := err.(smtpclient.Error)
@@ -221,7 +230,7 @@ func deliverSubmit(qlog mlog.Log, resolver dns.Resolver, dialer smtpclient.Diale
 		}()
 	}
 
-	deliverctx, delivercancel := context.WithTimeout(context.Background(), time.Duration(60+size/(1024*1024))*time.Second)
+	deliverctx, delivercancel := context.WithTimeout(context.WithoutCancel(ctx), time.Duration(60+size/(1024*1024))*time.Second)
 	defer delivercancel()
 	rcpts := make([]string, len(msgs))
 	for i, m := range msgs {
@@ -243,7 +252,8 @@ func processDeliveries(qlog mlog.Log, m0 *Msg, msgs []*Msg, remoteAddr string, r
 	for i, m := range msgs {
 		qmlog := qlog.With(
 			slog.Int64("msgid", m.ID),
-			slog.Any("recipient", m.Recipient()))
+			slog.Any("recipient", m.Recipient()),
+			slog.String("messageid", m.MessageID))
 
 		err := submiterr
 		if err == nil && len(rcptErrs) > i {
@@ -267,6 +277,8 @@ func processDeliveries(qlog mlog.Log, m0 *Msg, msgs []*Msg, remoteAddr string, r
 			m.markResult(0, "", "", true)
 			delMsgs = append(delMsgs, *m)
 			qmlog.Info("delivered from queue with transport")
+			messageEvent(qmlog, *m, admindb.EventDelivered, dsn.NameIP{Name: remoteHost}, "", "submitted to "+remoteAddr)
+			accounting.Sent(context.Background(), qmlog, m.SenderAccount, m.SenderDomain.Domain, m.Size)
 			delivered++
 		}
 	}
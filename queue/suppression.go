@@ -14,8 +14,6 @@ import (
 	"github.com/mjl-/mox/webapi"
 )
 
-// todo: we should be processing spam complaints and add addresses to the list.
-
 var errSuppressed = errors.New("address is on suppression list")
 
 func baseAddress(a smtp.Path) smtp.Path {
@@ -85,6 +83,42 @@ func SuppressionRemove(ctx context.Context, account string, address smtp.Path) e
 	return nil
 }
 
+// SuppressionComplaint adds addresses for which a spam complaint was received
+// about a message sent by an account to the suppression list of the account.
+// Addresses whose base address is already on the list are skipped. The addresses
+// that were added are returned.
+//
+// SuppressionComplaint does not check if an account exists.
+func SuppressionComplaint(ctx context.Context, log mlog.Log, account string, addresses []smtp.Path, reason string) (added []string, rerr error) {
+	rerr = DB.Write(ctx, func(tx *bstore.Tx) error {
+		for _, a := range addresses {
+			baseAddr := baseAddress(a).XString(true)
+			exists, err := bstore.QueryTx[webapi.Suppression](tx).FilterNonzero(webapi.Suppression{Account: account, BaseAddress: baseAddr}).Exists()
+			if err != nil {
+				return fmt.Errorf("checking if address is in suppression list: %v", err)
+			} else if exists {
+				log.Debug("address already in suppression list", slog.String("address", baseAddr))
+				continue
+			}
+			sup := webapi.Suppression{
+				Account:         account,
+				BaseAddress:     baseAddr,
+				OriginalAddress: a.XString(true),
+				Reason:          reason,
+			}
+			if err := tx.Insert(&sup); err != nil {
+				return fmt.Errorf("inserting suppression: %v", err)
+			}
+			added = append(added, sup.OriginalAddress)
+		}
+		return nil
+	})
+	if rerr != nil {
+		added = nil
+	}
+	return
+}
+
 type suppressionCheck struct {
 	MsgID     int64
 	Account   string
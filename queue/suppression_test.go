@@ -99,6 +99,21 @@ func TestSuppression(t *testing.T) {
 	err = SuppressionRemove(ctxbg, "retired", path2b)
 	tcheck(t, err, "lookup")
 
+	// Complaints add addresses not yet listed.
+	added, err := SuppressionComplaint(ctxbg, pkglog, "retired", []smtp.Path{path1, path2}, "spam complaint")
+	tcheck(t, err, "suppression for complaint")
+	tcompare(t, added, []string{path1.XString(true), path2.XString(true)})
+	added, err = SuppressionComplaint(ctxbg, pkglog, "retired", []smtp.Path{path2b}, "spam complaint")
+	tcheck(t, err, "suppression for complaint")
+	tcompare(t, len(added), 0)
+	sup, err = SuppressionLookup(ctxbg, "retired", path1)
+	tcheck(t, err, "lookup")
+	tcompare(t, sup.Reason, "spam complaint")
+	err = SuppressionRemove(ctxbg, "retired", path1)
+	tcheck(t, err, "remove suppression")
+	err = SuppressionRemove(ctxbg, "retired", path2)
+	tcheck(t, err, "remove suppression")
+
 	// Account names are not validated.
 	err = SuppressionAdd(ctxbg, path1, &webapi.Suppression{Account: "bogus"})
 	tcheck(t, err, "add suppression")
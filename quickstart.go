@@ -9,15 +9,18 @@ import (
 	cryptorand "crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"sort"
 	"strings"
 	"time"
@@ -59,16 +62,37 @@ func pwgen() string {
 }
 
 func cmdQuickstart(c *cmd) {
-	c.params = "[-skipdial] [-existing-webserver] [-hostname host] user@domain [user | uid]"
+	c.params = "[-skipdial] [-existing-webserver] [-hostname host] [-public-ip ip ...] [-admin-password-file file] [-account-password-file file] [-output systemd|docker-compose|k8s|cloud-init|none] [-json] user@domain ... [user | uid]"
 	c.help = `Quickstart generates configuration files and prints instructions to quickly set up a mox instance.
 
 Quickstart writes configuration files, prints initial admin and account
 passwords, DNS records you should create. If you run it on Linux it writes a
 systemd service file and prints commands to enable and start mox as service.
+With flag -output, quickstart instead writes a docker-compose.yml file for
+running mox with Docker Compose, or a mox-k8s.yaml file with Kubernetes
+manifests: a secret with the generated configuration files, volumes for the
+config and data directories, and a deployment with host networking and
+liveness/readiness probes using "mox healthcheck". With -output cloud-init,
+quickstart writes a cloud-init.yaml file with user-data for a new virtual
+machine, e.g. at a VPS provider: on first boot, it installs mox with "go
+install", writes the generated configuration files and systemd service file,
+starts mox and sets the account passwords. The configuration is for the virtual
+machine, not for the machine quickstart runs on, so flags -hostname and
+-public-ip are required, and the public listener is configured for the public
+IPs. The user-data installs Go from the distribution packages of Debian or
+Ubuntu, newer versions of Go are downloaded as needed.
 
 The user or uid is optional, defaults to "mox", and is the user or uid/gid mox
 will run as after initialization.
 
+Multiple email addresses can be specified to set up multiple domains and
+accounts at once. Each domain gets its own DKIM keys and DNS records. An
+account is created for each localpart, addresses with the same localpart in
+different domains are destinations of the same account. The first address is
+used for the postmaster account, the ACME contact address and as fallback for
+the hostname. Reports about a domain are delivered to the account of the first
+address in that domain.
+
 Quickstart assumes mox will run on the machine you run quickstart on and uses
 its host name and public IPs. On many systems the hostname is not a fully
 qualified domain name, but only the first dns "label", e.g. "mail" in case of
@@ -77,6 +101,16 @@ hostname, and as fallback uses the label plus the domain of the email address
 you specified. Use flag -hostname to explicitly specify the hostname mox will
 run on.
 
+Quickstart finds the IPs to listen on by looking at the network interfaces. On
+machines with only private IPs, e.g. cloud instances behind a NAT, quickstart
+uses the IPs of the host name as the public IPs. With split DNS, the host name
+may resolve to private IPs locally. Use flag -public-ip, once for each public
+IP, to specify the public IPs explicitly. If they are not configured on a
+network interface, the machine is assumed to be behind a NAT: the public
+listener listens on the private IPs, and the public IPs are configured as NATIPs
+in the public listener. A and AAAA records for the host name with the public IPs
+are added to the suggested DNS records.
+
 Mox is by far easiest to operate if you let it listen on port 443 (HTTPS) and
 80 (HTTP). TLS will be fully automatic with ACME with Let's Encrypt.
 
@@ -92,17 +126,73 @@ as a reverse proxy (and static file server for that matter), so you can forward
 traffic to your existing backend applications. Look for "WebHandlers:" in the
 output of "mox config describe-domains" and see the output of
 "mox config example webhandlers".
+
+For unattended provisioning, e.g. by configuration management tools, the admin
+and account passwords can be read from files with flags -admin-password-file
+and -account-password-file instead of being generated. A file name "-" reads
+the password from stdin, for at most one of the flags. Trailing newlines are
+removed. With multiple accounts, the account password file must have a line
+with a password for each account, in order of the addresses. With flag -json,
+the instructions are not printed. Instead, a JSON object is written to stdout
+with the hostname, the accounts, the paths of the files written, the DNS records
+to create for each domain, and the passwords that were generated. Errors are
+still written to stderr.
 `
 	var existingWebserver bool
 	var hostname string
 	var skipDial bool
+	var flagPublicIPs []string
+	var adminPasswordFile, accountPasswordFile string
+	var jsonOutput bool
+	var output string
 	c.flag.BoolVar(&existingWebserver, "existing-webserver", false, "use if a webserver is already running, so mox won't listen on port 80 and 443; you'll have to provide tls certificates/keys, and configure the existing webserver as reverse proxy, forwarding requests to mox.")
 	c.flag.StringVar(&hostname, "hostname", "", "hostname mox will run on, by default the hostname of the machine quickstart runs on; if specified, the IPs for the hostname are configured for the public listener")
 	c.flag.BoolVar(&skipDial, "skipdial", false, "skip check for outgoing smtp (port 25) connectivity")
+	c.flag.Func("public-ip", "public IP of this machine, when behind a NAT or with split DNS; can be repeated", func(s string) error {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return fmt.Errorf("invalid ip %q", s)
+		}
+		flagPublicIPs = append(flagPublicIPs, ip.String())
+		return nil
+	})
+	c.flag.StringVar(&adminPasswordFile, "admin-password-file", "", "read admin password from file instead of generating one, use \"-\" for stdin")
+	c.flag.StringVar(&accountPasswordFile, "account-password-file", "", "read account password from file instead of generating one, use \"-\" for stdin")
+	c.flag.StringVar(&output, "output", "", "how mox will be run, for generating a service file: systemd, docker-compose, k8s or none; default is systemd on linux when not running in docker")
+	c.flag.BoolVar(&jsonOutput, "json", false, "do not print instructions, but write a JSON summary with files written, DNS records and generated passwords to stdout")
 	args := c.Parse()
-	if len(args) != 1 && len(args) != 2 {
+	if len(args) == 0 {
 		c.Usage()
 	}
+	if output == "" {
+		// For now, we only give service config instructions for linux when not running in docker.
+		output = "none"
+		if runtime.GOOS == "linux" && os.Getenv("MOX_DOCKER") == "" {
+			output = "systemd"
+		}
+	}
+	switch output {
+	case "systemd", "docker-compose", "k8s", "cloud-init", "none":
+	default:
+		log.Fatalf("unknown value %q for -output, must be systemd, docker-compose, k8s, cloud-init or none", output)
+	}
+	if output == "cloud-init" {
+		if hostname == "" || len(flagPublicIPs) == 0 {
+			log.Fatalf("-output cloud-init requires flags -hostname and -public-ip")
+		}
+		// Outgoing connectivity of this machine says nothing about the new machine.
+		skipDial = true
+	}
+	if adminPasswordFile == "-" && accountPasswordFile == "-" {
+		log.Fatalf("only one of -admin-password-file and -account-password-file can read from stdin")
+	}
+
+	// Instructions are written to out, discarded when the JSON summary is requested.
+	var out io.Writer = os.Stdout
+	if jsonOutput {
+		out = io.Discard
+	}
+	var result quickstartResult
 
 	// We take care to cleanup created files when we error out.
 	// We don't want to get a new user into trouble with half of the files
@@ -130,6 +220,7 @@ output of "mox config describe-domains" and see the output of
 			fatalf("creating file %q: %s", path, err)
 		}
 		cleanupPaths = append(cleanupPaths, path)
+		result.Files = append(result.Files, path)
 		_, err = f.Write(data)
 		if err == nil {
 			err = f.Close()
@@ -139,21 +230,88 @@ output of "mox config describe-domains" and see the output of
 		}
 	}
 
-	addr, err := smtp.ParseAddress(args[0])
-	if err != nil {
-		fatalf("parsing email address: %s", err)
+	// All parameters are email addresses, except for an optional last user or uid.
+	user := "mox"
+	addrArgs := args
+	if len(args) > 1 && !strings.Contains(args[len(args)-1], "@") {
+		user = args[len(args)-1]
+		addrArgs = args[:len(args)-1]
 	}
+
+	// Accounts are named after the localparts of their addresses. The account of the
+	// first address in a domain receives the reports for that domain.
+	var addresses []smtp.Address
+	var accountNames []string
+	accountAddrs := map[string][]smtp.Address{}
+	var domains []dns.Domain
+	domainAccounts := map[string]string{}
+	for _, s := range addrArgs {
+		a, err := smtp.ParseAddress(s)
+		if err != nil {
+			fatalf("parsing email address %q: %s", s, err)
+		}
+		for _, oa := range addresses {
+			if oa == a {
+				fatalf("duplicate email address %s", a)
+			}
+		}
+		addresses = append(addresses, a)
+		name := a.Localpart.String()
+		if _, ok := accountAddrs[name]; !ok {
+			accountNames = append(accountNames, name)
+		}
+		accountAddrs[name] = append(accountAddrs[name], a)
+		if _, ok := domainAccounts[a.Domain.Name()]; !ok {
+			domains = append(domains, a.Domain)
+			domainAccounts[a.Domain.Name()] = name
+		}
+	}
+	addr := addresses[0]
 	accountName := addr.Localpart.String()
 	domain := addr.Domain
 
-	for _, c := range accountName {
-		if c > 0x7f {
-			fmt.Printf(`NOTE: Username %q is not ASCII-only. It is recommended you also configure an
+	// Read passwords before making any changes, so we can fail early.
+	var adminpw string
+	var err error
+	if adminPasswordFile != "" {
+		adminpw, err = readPasswordFile(adminPasswordFile)
+		if err != nil {
+			fatalf("reading admin password: %s", err)
+		}
+	}
+	accountPasswords := map[string]string{}
+	if accountPasswordFile != "" {
+		pw, err := readPasswordFile(accountPasswordFile)
+		if err != nil {
+			fatalf("reading account password: %s", err)
+		}
+		if len(accountNames) == 1 {
+			accountPasswords[accountName] = pw
+		} else {
+			lines := strings.Split(pw, "\n")
+			if len(lines) != len(accountNames) {
+				fatalf("reading account passwords: got %d lines, need a password for each of the %d accounts", len(lines), len(accountNames))
+			}
+			for i, name := range accountNames {
+				pw := strings.TrimRight(lines[i], "\r")
+				if pw == "" {
+					fatalf("reading account passwords: empty password for account %s", name)
+				}
+				accountPasswords[name] = pw
+			}
+		}
+	}
+
+	for _, name := range accountNames {
+		for _, c := range name {
+			if c > 0x7f {
+				fmt.Fprintf(out, `NOTE: Username %q is not ASCII-only. It is recommended you also configure an
 ASCII-only alias. Both for delivery of email from other systems, and for
 logging in with IMAP.
 
-`, accountName)
-			break
+`, name)
+				break
+			}
 		}
 	}
 
@@ -165,13 +323,13 @@ logging in with IMAP.
 	defer resolveCancel()
 
 	// Some DNSSEC-verifying resolvers return unauthentic data for ".", so we check "com".
-	fmt.Printf("Checking if DNS resolvers are DNSSEC-verifying...")
+	fmt.Fprintf(out, "Checking if DNS resolvers are DNSSEC-verifying...")
 	_, resolverDNSSECResult, err := resolver.LookupNS(resolveCtx, "com.")
 	if err != nil {
-		fmt.Println("")
+		fmt.Fprintln(out, "")
 		fatalf("checking dnssec support in resolver: %v", err)
 	} else if !resolverDNSSECResult.Authentic {
-		fmt.Printf(`
+		fmt.Fprintf(out, `
 
 WARNING: It looks like the DNS resolvers configured on your system do not
 verify DNSSEC, or aren't trusted (by having loopback IPs or through "options
@@ -194,7 +352,7 @@ EOF
 
 `)
 	} else {
-		fmt.Println(" OK")
+		fmt.Fprintln(out, " OK")
 	}
 
 	// We are going to find the (public) IPs to listen on and possibly the host name.
@@ -268,6 +426,12 @@ EOF
 		}
 	}
 
+	// Explicitly specified public IPs are used instead of IPs found on interfaces.
+	reverseIPs := publicIPs
+	if len(flagPublicIPs) > 0 {
+		reverseIPs = flagPublicIPs
+	}
+
 	var dnshostname dns.Domain
 	if hostname == "" {
 		hostnameStr, err := os.Hostname()
@@ -284,15 +448,15 @@ EOF
 			// is just the name without domain. We'll look up the names for all IPs, and hope
 			// to find a single FQDN name (with at least 1 dot).
 			names := map[string]struct{}{}
-			if len(publicIPs) > 0 {
-				fmt.Printf("Trying to find hostname by reverse lookup of public IPs %s...", strings.Join(publicIPs, ", "))
+			if len(reverseIPs) > 0 {
+				fmt.Fprintf(out, "Trying to find hostname by reverse lookup of public IPs %s...", strings.Join(reverseIPs, ", "))
 			}
 			var warned bool
 			warnf := func(format string, args ...any) {
 				warned = true
-				fmt.Printf("\n%s", fmt.Sprintf(format, args...))
+				fmt.Fprintf(out, "\n%s", fmt.Sprintf(format, args...))
 			}
-			for _, ip := range publicIPs {
+			for _, ip := range reverseIPs {
 				revctx, revcancel := context.WithTimeout(resolveCtx, 5*time.Second)
 				defer revcancel()
 				l, _, err := resolver.LookupAddr(revctx, ip)
@@ -315,7 +479,7 @@ EOF
 			if len(nameList) == 0 {
 				dnshostname, err = dns.ParseDomain(hostnameStr + "." + domain.Name())
 				if err != nil {
-					fmt.Println()
+					fmt.Fprintln(out)
 					fatalf("parsing hostname: %v", err)
 				}
 				warnf(`WARNING: cannot determine hostname because the system name is not an FQDN and
@@ -334,14 +498,14 @@ again with the -hostname flag.
 				}
 				dnshostname, err = dns.ParseDomain(nameList[0])
 				if err != nil {
-					fmt.Println()
+					fmt.Fprintln(out)
 					fatalf("parsing hostname %s: %v", nameList[0], err)
 				}
 			}
 			if warned {
-				fmt.Printf("\n\n")
+				fmt.Fprintf(out, "\n\n")
 			} else {
-				fmt.Printf(" found %s\n", dnshostname)
+				fmt.Fprintf(out, " found %s\n", dnshostname)
 			}
 		}
 	} else {
@@ -354,7 +518,7 @@ again with the -hostname flag.
 		}
 	}
 
-	fmt.Printf("Looking up IPs for hostname %s...", dnshostname)
+	fmt.Fprintf(out, "Looking up IPs for hostname %s...", dnshostname)
 	ipctx, ipcancel := context.WithTimeout(resolveCtx, 5*time.Second)
 	defer ipcancel()
 	ips, domainDNSSECResult, err := resolver.LookupIPAddr(ipctx, dnshostname.ASCII+".")
@@ -373,7 +537,7 @@ again with the -hostname flag.
 		// otherwise know their FQDN.
 		if ip.IP.IsLoopback() {
 			dnswarned = true
-			fmt.Printf("\n\nWARNING: Your hostname is resolving to a loopback IP address %s. This likely breaks email delivery to local accounts. /etc/hosts likely contains a line like %q. Either replace it with your actual IP(s), or remove the line.\n", ip.IP, fmt.Sprintf("%s %s", ip.IP, dnshostname.ASCII))
+			fmt.Fprintf(out, "\n\nWARNING: Your hostname is resolving to a loopback IP address %s. This likely breaks email delivery to local accounts. /etc/hosts likely contains a line like %q. Either replace it with your actual IP(s), or remove the line.\n", ip.IP, fmt.Sprintf("%s %s", ip.IP, dnshostname.ASCII))
 			continue
 		}
 		xips = append(xips, ip)
@@ -394,7 +558,66 @@ again with the -hostname flag.
 	// And we do log which host name we are using, and whether we detected a NAT setup.
 	// In the future, we may do an interactive setup that can guide the user better.
 
-	if !hostPrivate && len(publicIPs) == 0 && len(privateIPs) > 0 {
+	if len(flagPublicIPs) > 0 {
+		// If all public IPs are on an interface, we listen on them directly. Otherwise
+		// we are behind a NAT, and listen on the private IPs.
+		local := map[string]bool{}
+		for _, ip := range append(publicIPs, privateIPs...) {
+			local[ip] = true
+		}
+		natted := false
+		for _, ip := range flagPublicIPs {
+			if !local[ip] {
+				natted = true
+			}
+		}
+		if output == "cloud-init" {
+			// The config is for another machine, the IPs of this machine are not relevant.
+			publicListenerIPs = flagPublicIPs
+			defaultPublicListenerIPs = false
+			privateListenerIPs = []string{"127.0.0.1", "::1"}
+		} else if natted {
+			if len(privateIPs) > 0 {
+				publicListenerIPs = privateIPs
+				defaultPublicListenerIPs = false
+			}
+			publicNATIPs = flagPublicIPs
+			if len(loopbackIPs) > 0 {
+				privateListenerIPs = loopbackIPs
+			}
+		} else {
+			publicListenerIPs = flagPublicIPs
+			defaultPublicListenerIPs = false
+			var npriv []string
+			for _, ip := range privateIPs {
+				if !slices.Contains(flagPublicIPs, ip) {
+					npriv = append(npriv, ip)
+				}
+			}
+			privateListenerIPs = append(npriv, loopbackIPs...)
+			if len(privateListenerIPs) == 0 {
+				privateListenerIPs = []string{"127.0.0.1", "::1"}
+			}
+		}
+
+		// Warn about split DNS, e.g. the host name resolving to private IPs on this
+		// machine, but to the public IPs elsewhere.
+		var mismatch bool
+		for _, ip := range hostIPs {
+			if !slices.Contains(flagPublicIPs, ip) {
+				mismatch = true
+			}
+		}
+		if mismatch {
+			fmt.Fprintf(out, `
+NOTE: Host name %s resolves to %s on this machine, not to the specified public
+IPs %s. With split DNS, this is expected. Make sure the host name resolves to
+the public IPs in public DNS.
+
+`, dnshostname, strings.Join(hostIPs, ", "), strings.Join(flagPublicIPs, ", "))
+		}
+		hostIPs = flagPublicIPs // For DNSBL check below.
+	} else if !hostPrivate && len(publicIPs) == 0 && len(privateIPs) > 0 {
 		// We only have private IPs, assume we are behind a NAT and put the IPs of the host in NATIPs.
 		publicListenerIPs = privateIPs
 		publicNATIPs = hostIPs
@@ -434,10 +657,10 @@ again with the -hostname flag.
 	}
 	if err != nil {
 		if !dnswarned {
-			fmt.Printf("\n")
+			fmt.Fprintf(out, "\n")
 		}
 		dnswarned = true
-		fmt.Printf(`
+		fmt.Fprintf(out, `
 WARNING: Quickstart assumed the hostname of this machine is %s and generates a
 config for that host, but could not retrieve that name from DNS:
 
@@ -456,10 +679,10 @@ This likely means one of two things:
 `, dnshostname, err)
 	} else if !domainDNSSECResult.Authentic {
 		if !dnswarned {
-			fmt.Printf("\n")
+			fmt.Fprintf(out, "\n")
 		}
 		dnswarned = true
-		fmt.Printf(`
+		fmt.Fprintf(out, `
 NOTE: It looks like the DNS records of your domain (zone) are not DNSSEC-signed.
 Mail servers that send email to your domain, or receive email from your domain,
 cannot verify that the MX/SPF/DKIM/DMARC/MTA-STS records they receive are
@@ -474,7 +697,7 @@ messages over SMTP.
 	}
 
 	if !dnswarned {
-		fmt.Printf(" OK\n")
+		fmt.Fprintf(out, " OK\n")
 
 		var l []string
 		type result struct {
@@ -493,10 +716,10 @@ messages over SMTP.
 				results <- result{s, addrs, err}
 			}()
 		}
-		fmt.Printf("Looking up reverse names for IP(s) %s...", strings.Join(l, ", "))
+		fmt.Fprintf(out, "Looking up reverse names for IP(s) %s...", strings.Join(l, ", "))
 		var warned bool
 		warnf := func(format string, args ...any) {
-			fmt.Printf("\nWARNING: %s", fmt.Sprintf(format, args...))
+			fmt.Fprintf(out, "\nWARNING: %s", fmt.Sprintf(format, args...))
 			warned = true
 		}
 		for i := 0; i < len(ips); i++ {
@@ -525,46 +748,31 @@ messages over SMTP.
 			}
 		}
 		if warned {
-			fmt.Printf("\n\n")
+			fmt.Fprintf(out, "\n\n")
 		} else {
-			fmt.Printf(" OK\n")
+			fmt.Fprintf(out, " OK\n")
 		}
 	}
 
 	// Check outgoing SMTP connectivity.
 	if !skipDial {
-		fmt.Printf("Checking if outgoing smtp connections can be made by connecting to gmail.com mx on port 25...")
-		mxctx, mxcancel := context.WithTimeout(context.Background(), 5*time.Second)
-		mx, _, err := resolver.LookupMX(mxctx, "gmail.com.")
-		mxcancel()
-		if err == nil && len(mx) == 0 {
-			err = errors.New("no mx records")
-		}
+		fmt.Fprintf(out, "Checking if outgoing smtp connections can be made by connecting to gmail.com mx on port 25...")
 		var ok bool
-		if err != nil {
-			fmt.Printf("\n\nERROR: looking up gmail.com mx record: %s\n", err)
+		if err := preflightDialSMTP(context.Background(), resolver, &net.Dialer{}); err != nil {
+			fmt.Fprintf(out, "\n\nERROR: %s\n", err)
 		} else {
-			dialctx, dialcancel := context.WithTimeout(context.Background(), 10*time.Second)
-			d := net.Dialer{}
-			addr := net.JoinHostPort(mx[0].Host, "25")
-			conn, err := d.DialContext(dialctx, "tcp", addr)
-			dialcancel()
-			if err != nil {
-				fmt.Printf("\n\nERROR: connecting to %s: %s\n", addr, err)
-			} else {
-				conn.Close()
-				fmt.Printf(" OK\n")
-				ok = true
-			}
+			fmt.Fprintf(out, " OK\n")
+			ok = true
 		}
 		if !ok {
-			fmt.Printf(`
+			fmt.Fprintf(out, `
 WARNING: Could not verify outgoing smtp connections can be made, outgoing
 delivery may not be working. Many providers block outgoing smtp connections by
 default, requiring an explicit request or a cooldown period before allowing
 outgoing smtp connections. To send through a smarthost, configure a "Transport"
 in mox.conf and use it in "Routes" in domains.conf. See
-"mox config example transport".
+"mox config example transport". See "mox help preflight listen" for checking
+connectivity in more detail.
 
 `)
 		}
@@ -575,7 +783,7 @@ in mox.conf and use it in "Routes" in domains.conf. See
 		{ASCII: "bl.spamcop.net"},
 	}
 	if len(hostIPs) > 0 {
-		fmt.Printf("Checking whether host name IPs are listed in popular DNS block lists...")
+		fmt.Fprintf(out, "Checking whether host name IPs are listed in popular DNS block lists...")
 		var listed bool
 		for _, zone := range zones {
 			for _, ip := range hostIPs {
@@ -589,7 +797,7 @@ in mox.conf and use it in "Routes" in domains.conf. See
 				if err != nil {
 					errstr = fmt.Sprintf(" (%s)", err)
 				}
-				fmt.Printf("\nWARNING: checking your public IP %s in DNS block list %s: %v %s%s", ip, zone.Name(), status, expl, errstr)
+				fmt.Fprintf(out, "\nWARNING: checking your public IP %s in DNS block list %s: %v %s%s", ip, zone.Name(), status, expl, errstr)
 				listed = true
 			}
 		}
@@ -602,11 +810,11 @@ listed in more DNS block lists, visit:
 
 `)
 			for _, ip := range hostIPs {
-				fmt.Printf("- https://multirbl.valli.org/lookup/%s.html\n", url.PathEscape(ip))
+				fmt.Fprintf(out, "- https://multirbl.valli.org/lookup/%s.html\n", url.PathEscape(ip))
 			}
-			fmt.Printf("\n")
+			fmt.Fprintf(out, "\n")
 		} else {
-			fmt.Printf(" OK\n")
+			fmt.Fprintf(out, " OK\n")
 		}
 	}
 
@@ -621,7 +829,17 @@ actual public IPs in the listener's "NATIPs" option.
 
 `)
 	}
-	if len(publicNATIPs) > 0 {
+	if len(publicNATIPs) > 0 && len(flagPublicIPs) > 0 {
+		log.Printf(`
+NOTE: The specified public IPs are not configured on a network interface of this
+machine, so quickstart assumes it is behind a NAT. The public listener listens
+on the private IPs, and the public IPs are configured in its NATIPs field. The
+NAT must forward the ports for SMTP, submission, IMAP, HTTP and HTTPS to this
+machine, and must preserve the remote IPs of connections: IP-based policies like
+SPF, junk classification and rate limiting depend on them.
+
+`)
+	} else if len(publicNATIPs) > 0 {
 		log.Printf(`
 NOTE: Quickstart used the IPs of the host name of the mail server, but only
 found private IPs on the machine. This indicates this machine is behind a NAT,
@@ -636,14 +854,9 @@ many authentication failures).
 `)
 	}
 
-	fmt.Printf("\n")
-
-	user := "mox"
-	if len(args) == 2 {
-		user = args[1]
-	}
+	fmt.Fprintf(out, "\n")
 
-	dc := config.Dynamic{}
+	dc := config.Dynamic{Version: mox.ConfigDynamicVersion}
 	sc := config.Static{
 		DataDir:           filepath.FromSlash("../data"),
 		User:              user,
@@ -673,13 +886,16 @@ many authentication failures).
 
 	dataDir := "data" // ../data is relative to config/
 	os.MkdirAll(dataDir, 0770)
-	adminpw := pwgen()
+	if adminpw == "" {
+		adminpw = pwgen()
+		result.AdminPassword = adminpw
+	}
 	adminpwhash, err := bcrypt.GenerateFromPassword([]byte(adminpw), bcrypt.DefaultCost)
 	if err != nil {
 		fatalf("generating hash for generated admin password: %s", err)
 	}
 	xwritefile(filepath.Join("config", sc.AdminPasswordFile), adminpwhash, 0660)
-	fmt.Printf("Admin password: %s\n", adminpw)
+	fmt.Fprintf(out, "Admin password: %s\n", adminpw)
 
 	public := config.Listener{
 		IPs:    publicListenerIPs,
@@ -691,17 +907,21 @@ many authentication failures).
 
 	if existingWebserver {
 		hostbase := filepath.FromSlash("path/to/" + dnshostname.Name())
-		mtastsbase := filepath.FromSlash("path/to/mta-sts." + domain.Name())
-		autoconfigbase := filepath.FromSlash("path/to/autoconfig." + domain.Name())
 		public.TLS = &config.TLS{
 			KeyCerts: []config.KeyCert{
 				{CertFile: hostbase + "-chain.crt.pem", KeyFile: hostbase + ".key.pem"},
-				{CertFile: mtastsbase + "-chain.crt.pem", KeyFile: mtastsbase + ".key.pem"},
-				{CertFile: autoconfigbase + "-chain.crt.pem", KeyFile: autoconfigbase + ".key.pem"},
 			},
 		}
+		for _, d := range domains {
+			mtastsbase := filepath.FromSlash("path/to/mta-sts." + d.Name())
+			autoconfigbase := filepath.FromSlash("path/to/autoconfig." + d.Name())
+			public.TLS.KeyCerts = append(public.TLS.KeyCerts,
+				config.KeyCert{CertFile: mtastsbase + "-chain.crt.pem", KeyFile: mtastsbase + ".key.pem"},
+				config.KeyCert{CertFile: autoconfigbase + "-chain.crt.pem", KeyFile: autoconfigbase + ".key.pem"},
+			)
+		}
 
-		fmt.Println(
+		fmt.Fprintln(out,
 			`Placeholder paths to TLS certificates to be provided by the existing webserver
 have been placed in config/mox.conf and need to be edited.
 
@@ -776,6 +996,7 @@ and check the admin page for the needed DNS records.`)
 	internal.AdminHTTP.Enabled = true
 	internal.WebmailHTTP.Enabled = true
 	internal.WebAPIHTTP.Enabled = true
+	internal.DAVHTTP.Enabled = true
 	internal.MetricsHTTP.Enabled = true
 	if existingWebserver {
 		internal.AccountHTTP.Port = 1080
@@ -786,6 +1007,8 @@ and check the admin page for the needed DNS records.`)
 		internal.WebmailHTTP.Forwarded = true
 		internal.WebAPIHTTP.Port = 1080
 		internal.WebAPIHTTP.Forwarded = true
+		internal.DAVHTTP.Port = 1080
+		internal.DAVHTTP.Forwarded = true
 		internal.AutoconfigHTTPS.Enabled = true
 		internal.AutoconfigHTTPS.Port = 81
 		internal.AutoconfigHTTPS.NonTLS = true
@@ -811,19 +1034,25 @@ and check the admin page for the needed DNS records.`)
 
 	mox.Conf.DynamicLastCheck = time.Now() // Prevent error logging by Make calls below.
 
-	accountConf := mox.MakeAccountConfig(addr)
-	const withMTASTS = true
-	confDomain, keyPaths, err := mox.MakeDomainConfig(context.Background(), domain, dnshostname, accountName, withMTASTS)
-	if err != nil {
-		fatalf("making domain config: %s", err)
-	}
-	cleanupPaths = append(cleanupPaths, keyPaths...)
-
-	dc.Domains = map[string]config.Domain{
-		domain.Name(): confDomain,
+	dc.Accounts = map[string]config.Account{}
+	for _, name := range accountNames {
+		l := accountAddrs[name]
+		accountConf := mox.MakeAccountConfig(l[0])
+		for _, a := range l[1:] {
+			accountConf.Destinations[a.String()] = config.Destination{}
+		}
+		dc.Accounts[name] = accountConf
 	}
-	dc.Accounts = map[string]config.Account{
-		accountName: accountConf,
+	const withMTASTS = true
+	dc.Domains = map[string]config.Domain{}
+	for _, d := range domains {
+		confDomain, keyPaths, err := mox.MakeDomainConfig(context.Background(), d, dnshostname, domainAccounts[d.Name()], withMTASTS)
+		if err != nil {
+			fatalf("making domain config for %s: %s", d, err)
+		}
+		cleanupPaths = append(cleanupPaths, keyPaths...)
+		result.Files = append(result.Files, keyPaths...)
+		dc.Domains[d.Name()] = confDomain
 	}
 
 	// Build config in memory, so we can easily comment out the DNSBLs config.
@@ -897,40 +1126,55 @@ and check the admin page for the needed DNS records.`)
 	// and set a passsword, and the public key for the DKIM private keys
 	// are available for generating the DKIM DNS records below.
 
-	confDomain, ok := mc.Domain(domain)
-	if !ok {
-		fatalf("cannot find domain in new config")
-	}
-
-	acc, _, err := store.OpenEmail(c.log, args[0])
-	if err != nil {
-		fatalf("open account: %s", err)
-	}
-	cleanupPaths = append(cleanupPaths, dataDir, filepath.Join(dataDir, "accounts"), filepath.Join(dataDir, "accounts", accountName), filepath.Join(dataDir, "accounts", accountName, "index.db"))
-
-	password := pwgen()
+	cleanupPaths = append(cleanupPaths, dataDir, filepath.Join(dataDir, "accounts"))
+	for _, name := range accountNames {
+		accAddr := accountAddrs[name][0]
+		acc, _, err := store.OpenEmail(c.log, accAddr.String())
+		if err != nil {
+			fatalf("open account: %s", err)
+		}
+		cleanupPaths = append(cleanupPaths, filepath.Join(dataDir, "accounts", name), filepath.Join(dataDir, "accounts", name, "index.db"))
+		result.Files = append(result.Files, filepath.Join(dataDir, "accounts", name, "index.db"))
 
-	// Kludge to cause no logging to be printed about setting a new password.
-	loglevel := mox.Conf.Log[""]
-	mox.Conf.Log[""] = mlog.LevelWarn
-	mlog.SetConfig(mox.Conf.Log)
-	if err := acc.SetPassword(c.log, password); err != nil {
-		fatalf("setting password: %s", err)
-	}
-	mox.Conf.Log[""] = loglevel
-	mlog.SetConfig(mox.Conf.Log)
+		racc := quickstartAccount{Account: name}
+		for _, a := range accountAddrs[name] {
+			racc.Addresses = append(racc.Addresses, a.String())
+		}
+		password := accountPasswords[name]
+		if password == "" {
+			password = pwgen()
+			racc.Password = password
+			accountPasswords[name] = password
+		}
+		result.Accounts = append(result.Accounts, racc)
+
+		// Kludge to cause no logging to be printed about setting a new password.
+		loglevel := mox.Conf.Log[""]
+		mox.Conf.Log[""] = mlog.LevelWarn
+		mlog.SetConfig(mox.Conf.Log)
+		if err := acc.SetPassword(c.log, password); err != nil {
+			fatalf("setting password: %s", err)
+		}
+		mox.Conf.Log[""] = loglevel
+		mlog.SetConfig(mox.Conf.Log)
 
-	if err := acc.Close(); err != nil {
-		fatalf("closing account: %s", err)
+		if err := acc.Close(); err != nil {
+			fatalf("closing account: %s", err)
+		}
+		fmt.Fprintf(out, "IMAP, SMTP submission and HTTP account password for %s: %s\n", accAddr, password)
 	}
-	fmt.Printf("IMAP, SMTP submission and HTTP account password for %s: %s\n\n", args[0], password)
-	fmt.Printf(`When configuring your email client, use the email address as username. If
+	fmt.Fprintf(out, "\n")
+	fmt.Fprintf(out, `When configuring your email client, use the email address as username. If
 autoconfig/autodiscover does not work, use these settings:
 `)
-	printClientConfig(domain)
+	printClientConfig(out, domain)
 
 	if existingWebserver {
-		fmt.Printf(`
+		var forwardURLs string
+		for _, d := range domains {
+			forwardURLs += fmt.Sprintf("\thttps://mta-sts.%s/\n\thttps://autoconfig.%s/\n", d.ASCII, d.ASCII)
+		}
+		fmt.Fprintf(out, `
 Configuration files have been written to config/mox.conf and
 config/domains.conf.
 
@@ -940,9 +1184,7 @@ records, and has a page to check they have been configured correctly.
 
 You must configure your existing webserver to forward requests for:
 
-	https://mta-sts.%s/
-	https://autoconfig.%s/
-
+%s
 To mox, at:
 
 	http://127.0.0.1:81
@@ -957,9 +1199,9 @@ config is valid, run:
 	./mox config test
 
 The DNS records to add:
-`, domain.ASCII, domain.ASCII, dnshostname.ASCII)
+`, forwardURLs, dnshostname.ASCII)
 	} else {
-		fmt.Printf(`
+		fmt.Fprintf(out, `
 Configuration files have been written to config/mox.conf and
 config/domains.conf. You should review them. Then create the DNS records below,
 by adding them to your zone file or through the web interface of your DNS
@@ -973,35 +1215,81 @@ have been configured correctly. The DNS records to add:
 	// priming dns caches with negative/absent records, causing our "quick setup" to
 	// appear to fail or take longer than "quick".
 
-	records, err := mox.DomainRecords(confDomain, domain, domainDNSSECResult.Authentic, "letsencrypt.org", "")
-	if err != nil {
-		fatalf("making required DNS records")
+	fmt.Fprint(out, "\n\n")
+	if len(flagPublicIPs) > 0 {
+		// With explicitly specified public IPs, we know what the host name should resolve to.
+		records := []string{
+			"; Host name of the mail server, resolving to its public IPs. Also configure",
+			"; reverse DNS for the public IPs to resolve to the host name, at the owner of",
+			"; the IPs, typically your hosting provider.",
+		}
+		for _, ip := range flagPublicIPs {
+			rtype := "A"
+			if net.ParseIP(ip).To4() == nil {
+				rtype = "AAAA"
+			}
+			records = append(records, fmt.Sprintf("%-40s %-4s %s", dnshostname.ASCII+".", rtype, ip))
+		}
+		fmt.Fprint(out, strings.Join(records, "\n")+"\n\n")
+		result.HostRecords = records
+	}
+	for _, d := range domains {
+		confDomain, ok := mc.Domain(d)
+		if !ok {
+			fatalf("cannot find domain %s in new config", d)
+		}
+		records, err := mox.DomainRecords(confDomain, d, domainDNSSECResult.Authentic, "letsencrypt.org", "")
+		if err != nil {
+			fatalf("making required DNS records for %s", d)
+		}
+		fmt.Fprint(out, strings.Join(records, "\n")+"\n\n")
+		result.Domains = append(result.Domains, quickstartDomain{d.Name(), records})
 	}
-	fmt.Print("\n\n" + strings.Join(records, "\n") + "\n\n\n\n")
+	fmt.Fprint(out, "\n\n")
 
-	fmt.Printf(`WARNING: The configuration and DNS records above assume you do not currently
+	fmt.Fprintf(out, `WARNING: The configuration and DNS records above assume you do not currently
 have email configured for your domain. If you do already have email configured,
 or if you are sending email for your domain from other machines/services, you
 should understand the consequences of the DNS records above before
 continuing!
 `)
-	if os.Getenv("MOX_DOCKER") == "" {
-		fmt.Printf(`
+	if output == "docker-compose" || output == "k8s" || output == "cloud-init" {
+		// Instructions for starting are printed below.
+	} else if os.Getenv("MOX_DOCKER") == "" {
+		fmt.Fprintf(out, `
 You can now start mox with "./mox serve", as root.
 `)
 	} else {
-		fmt.Printf(`
+		fmt.Fprintf(out, `
 You can now start the mox container.
 `)
 	}
-	fmt.Printf(`
+	fmt.Fprintf(out, `
 File ownership and permissions are automatically set correctly by mox when
 starting up. On linux, you may want to enable mox as a systemd service.
 
 `)
 
-	// For now, we only give service config instructions for linux when not running in docker.
-	if runtime.GOOS == "linux" && os.Getenv("MOX_DOCKER") == "" {
+	// Config files written, keyed by path relative to the config directory, for
+	// including in generated files.
+	xconfigFiles := func() map[string][]byte {
+		configFiles := map[string][]byte{}
+		for _, p := range result.Files {
+			rel, err := filepath.Rel("config", p)
+			if err != nil || strings.HasPrefix(rel, "..") {
+				continue
+			}
+			buf, err := os.ReadFile(p)
+			if err != nil {
+				fatalf("reading config file: %v", err)
+			}
+			configFiles[filepath.ToSlash(rel)] = buf
+		}
+		return configFiles
+	}
+
+	switch output {
+	case "systemd":
 		pwd, err := os.Getwd()
 		if err != nil {
 			log.Printf("current working directory: %v", err)
@@ -1009,17 +1297,59 @@ starting up. On linux, you may want to enable mox as a systemd service.
 		}
 		service := strings.ReplaceAll(moxService, "/home/mox", pwd)
 		xwritefile("mox.service", []byte(service), 0644)
-		cleanupPaths = append(cleanupPaths, "mox.service")
-		fmt.Printf(`See mox.service for a systemd service file. To enable and start:
+		fmt.Fprintf(out, `See mox.service for a systemd service file. To enable and start:
 
 	sudo chmod 644 mox.service
 	sudo systemctl enable $PWD/mox.service
 	sudo systemctl start mox.service
 	sudo journalctl -f -u mox.service # See logs
 `)
+
+	case "docker-compose":
+		xwritefile("docker-compose.yml", []byte(quickstartDockerCompose()), 0644)
+		fmt.Fprintf(out, `See docker-compose.yml for running mox with Docker Compose, with the config
+and data directories as volumes. To start:
+
+	docker compose up -d
+	docker compose logs -f # See logs
+`)
+
+	case "k8s":
+		configFiles := xconfigFiles()
+		xwritefile("mox-k8s.yaml", []byte(quickstartK8s(configFiles)), 0600)
+		fmt.Fprintf(out, `See mox-k8s.yaml for Kubernetes manifests. It contains a secret with the
+generated configuration files, including private keys, so keep it safe. Mox
+runs with host networking, see the comments in the file. To start:
+
+	kubectl apply -f mox-k8s.yaml
+	kubectl logs -f deploy/mox # See logs
+
+The account passwords are stored in the local data directory, which is not
+part of the manifests. After starting, set the passwords again:
+
+`)
+		for _, name := range accountNames {
+			fmt.Fprintf(out, "\tkubectl exec -it deploy/mox -- mox setaccountpassword %s\n", name)
+		}
+
+	case "cloud-init":
+		configFiles := xconfigFiles()
+		var passwords []string
+		for _, name := range accountNames {
+			passwords = append(passwords, accountPasswords[name])
+		}
+		xwritefile("cloud-init.yaml", []byte(quickstartCloudInit(configFiles, accountNames, passwords)), 0600)
+		fmt.Fprintf(out, `See cloud-init.yaml for user-data to provide when creating a virtual machine
+for %s with IPs %s. It contains the generated configuration files, including
+private keys, and the account passwords, so keep it safe. Mox is installed and
+started on first boot. Check progress on the machine with:
+
+	sudo cloud-init status --wait
+	sudo journalctl -f -u mox.service # See logs
+`, dnshostname, strings.Join(flagPublicIPs, ", "))
 	}
 
-	fmt.Printf(`
+	fmt.Fprintf(out, `
 After starting mox, the web interfaces are served at:
 
 http://localhost/         - account (email address as username)
@@ -1037,11 +1367,61 @@ Enjoy!
 `)
 
 	if !existingWebserver {
-		fmt.Printf(`
+		fmt.Fprintf(out, `
 PS: If you want to run mox along side an existing webserver that uses port 443
 and 80, see "mox help quickstart" with the -existing-webserver option.
 `)
 	}
 
+	if jsonOutput {
+		result.Hostname = dnshostname.Name()
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		if err := enc.Encode(result); err != nil {
+			fatalf("writing json summary: %s", err)
+		}
+	}
+
 	cleanupPaths = nil
 }
+
+// quickstartResult is written to stdout by quickstart with flag -json.
+type quickstartResult struct {
+	Hostname      string
+	AdminPassword string   `json:",omitempty"` // Only set if generated.
+	HostRecords   []string `json:",omitempty"` // DNS records for the host name, only with flag -public-ip.
+	Accounts      []quickstartAccount
+	Domains       []quickstartDomain
+	Files         []string // Paths of files written, relative to the working directory.
+}
+
+type quickstartAccount struct {
+	Account   string
+	Addresses []string
+	Password  string `json:",omitempty"` // Only set if generated.
+}
+
+type quickstartDomain struct {
+	Domain     string
+	DNSRecords []string // Lines for a zone file, including comments.
+}
+
+// readPasswordFile reads a password from a file, or stdin for "-", removing a
+// trailing newline.
+func readPasswordFile(path string) (string, error) {
+	var buf []byte
+	var err error
+	if path == "-" {
+		buf, err = io.ReadAll(os.Stdin)
+	} else {
+		buf, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return "", err
+	}
+	pw := strings.TrimRight(string(buf), "\r\n")
+	if pw == "" {
+		return "", fmt.Errorf("empty password")
+	}
+	return pw, nil
+}
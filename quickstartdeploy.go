@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mjl-/mox/moxvar"
+)
+
+// quickstartImage is the container image referenced in generated compose files
+// and kubernetes manifests.
+const quickstartImage = "r.xmox.nl/mox:latest"
+
+// quickstartDockerCompose returns a docker compose file for running mox with the
+// config and data directories created by quickstart.
+func quickstartDockerCompose() string {
+	return `# Docker compose file for mox, generated by "mox quickstart". Start with:
+#
+#	docker compose up -d
+
+services:
+  mox:
+    # Replace "latest" with the version you want to run, see https://r.xmox.nl/r/mox/.
+    # Include the @sha256:... digest to ensure you get the listed image.
+    image: ` + quickstartImage + `
+    environment:
+      - MOX_DOCKER=yes # Quickstart won't try to write systemd service file.
+    # Mox needs host networking because it needs access to the IPs of the
+    # machine, and the IPs of incoming connections for spam filtering.
+    network_mode: 'host'
+    volumes:
+      - ./config:/mox/config
+      - ./data:/mox/data
+      # web is optional but recommended to bind in, useful for serving static files with
+      # the webserver.
+      - ./web:/mox/web
+    working_dir: /mox
+    restart: on-failure
+    healthcheck:
+      test: ["CMD", "mox", "healthcheck", "-live"]
+      interval: 30s
+      timeout: 10s
+      retries: 3
+`
+}
+
+// quickstartK8s returns kubernetes manifests for running mox. The configuration
+// files, keyed by path relative to the config directory, are stored in a secret,
+// and copied to the config volume on first start.
+func quickstartK8s(configFiles map[string][]byte) string {
+	var paths []string
+	for p := range configFiles {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	// Keys in secrets cannot contain slashes, the items of the volume map them back
+	// to paths.
+	secretKey := func(p string) string {
+		return strings.ReplaceAll(p, "/", "__")
+	}
+
+	var b strings.Builder
+	b.WriteString(`# Kubernetes manifests for mox, generated by "mox quickstart". Apply with:
+#
+#	kubectl apply -f mox-k8s.yaml
+#
+# Mox needs host networking: it listens on the IPs of the node, and needs the IPs
+# of incoming connections for spam filtering. Run it on a single node, e.g. by
+# adding a nodeSelector, and make sure the DNS records for the host name point to
+# that node.
+#
+# The configuration files generated by quickstart, including private keys, are
+# in the secret below. On first start, they are copied to the writable config
+# volume, so configuration changes made through the admin interface persist.
+# Later changes to the secret are not applied to the config volume.
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: mox-config
+type: Opaque
+data:
+`)
+	for _, p := range paths {
+		fmt.Fprintf(&b, "  %s: %s\n", secretKey(p), base64.StdEncoding.EncodeToString(configFiles[p]))
+	}
+	b.WriteString(`---
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: mox-config
+spec:
+  accessModes:
+    - ReadWriteOnce
+  resources:
+    requests:
+      storage: 100Mi
+---
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: mox-data
+spec:
+  accessModes:
+    - ReadWriteOnce
+  resources:
+    requests:
+      storage: 10Gi
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: mox
+spec:
+  replicas: 1
+  # Only a single instance can use the data directory.
+  strategy:
+    type: Recreate
+  selector:
+    matchLabels:
+      app: mox
+  template:
+    metadata:
+      labels:
+        app: mox
+    spec:
+      hostNetwork: true
+      # Use the resolvers of the node, ideally a DNSSEC-verifying resolver.
+      dnsPolicy: Default
+      initContainers:
+        - name: config
+          image: ` + quickstartImage + `
+          command: ["sh", "-c", "test -e /mox/config/mox.conf || cp -rL /secret/* /mox/config/"]
+          volumeMounts:
+            - name: config
+              mountPath: /mox/config
+            - name: secret
+              mountPath: /secret
+              readOnly: true
+      containers:
+        - name: mox
+          # Replace "latest" with the version you want to run, see https://r.xmox.nl/r/mox/.
+          image: ` + quickstartImage + `
+          command: ["mox", "serve"]
+          workingDir: /mox
+          env:
+            - name: MOX_DOCKER
+              value: "yes"
+          ports:
+`)
+	for _, p := range preflightPorts {
+		fmt.Fprintf(&b, "            - name: %s\n              containerPort: %d\n", p.Service, p.Port)
+	}
+	b.WriteString(`          livenessProbe:
+            exec:
+              command: ["mox", "healthcheck", "-live"]
+            initialDelaySeconds: 10
+            periodSeconds: 30
+            timeoutSeconds: 10
+          readinessProbe:
+            exec:
+              command: ["mox", "healthcheck"]
+            periodSeconds: 30
+            timeoutSeconds: 10
+          volumeMounts:
+            - name: config
+              mountPath: /mox/config
+            - name: data
+              mountPath: /mox/data
+      volumes:
+        - name: config
+          persistentVolumeClaim:
+            claimName: mox-config
+        - name: data
+          persistentVolumeClaim:
+            claimName: mox-data
+        - name: secret
+          secret:
+            secretName: mox-config
+            items:
+`)
+	for _, p := range paths {
+		fmt.Fprintf(&b, "              - key: %s\n                path: %s\n", secretKey(p), p)
+	}
+	return b.String()
+}
+
+// quickstartCloudInit returns cloud-init user-data that installs mox on a new
+// machine, writes the configuration files and service file, starts mox and sets
+// the passwords of the accounts.
+func quickstartCloudInit(configFiles map[string][]byte, accounts, passwords []string) string {
+	var paths []string
+	for p := range configFiles {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	version := "latest"
+	if strings.HasPrefix(moxvar.VersionBare, "v") {
+		version = moxvar.VersionBare
+	}
+
+	var b strings.Builder
+	b.WriteString(`#cloud-config
+# User-data for cloud-init, generated by "mox quickstart". On first boot, it
+# installs mox in /home/mox, writes the configuration files generated by
+# quickstart, starts mox as systemd service, and sets the account passwords.
+# It contains private keys and passwords, keep it safe.
+
+package_update: true
+packages:
+  # Go from Debian/Ubuntu, for "go install". Newer toolchains are downloaded as
+  # needed.
+  - golang-go
+
+write_files:
+`)
+	writeFile := func(path, perm string, data []byte) {
+		fmt.Fprintf(&b, "  - path: %s\n    permissions: '%s'\n    encoding: b64\n    content: %s\n", path, perm, base64.StdEncoding.EncodeToString(data))
+	}
+	for _, p := range paths {
+		writeFile("/home/mox/config/"+p, "0660", configFiles[p])
+	}
+	writeFile("/etc/systemd/system/mox.service", "0644", []byte(moxService))
+	for i := range accounts {
+		writeFile(fmt.Sprintf("/root/mox-password-%d", i), "0600", []byte(passwords[i]+"\n"))
+	}
+
+	shquote := func(s string) string {
+		return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+	}
+	cmds := [][]string{
+		{"sh", "-c", "id mox || useradd --system --home-dir /home/mox --no-create-home --shell /usr/sbin/nologin mox"},
+		{"sh", "-c", "cd /home/mox && HOME=/root GOBIN=/home/mox CGO_ENABLED=0 GOTOOLCHAIN=auto go install github.com/mjl-/mox@" + version},
+		{"mkdir", "-p", "/home/mox/data"},
+		{"systemctl", "daemon-reload"},
+		{"systemctl", "enable", "--now", "mox.service"},
+		// Setting passwords requires a running mox.
+		{"sh", "-c", "for i in $(seq 60); do test -S /home/mox/data/ctl && break; sleep 1; done"},
+	}
+	for i, name := range accounts {
+		pwpath := fmt.Sprintf("/root/mox-password-%d", i)
+		cmds = append(cmds, []string{"sh", "-c", fmt.Sprintf("cd /home/mox && ./mox setaccountpassword %s <%s && rm %s", shquote(name), pwpath, pwpath)})
+	}
+	b.WriteString("\nruncmd:\n")
+	for _, cmd := range cmds {
+		// JSON arrays are valid YAML.
+		buf, err := json.Marshal(cmd)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Fprintf(&b, "  - %s\n", buf)
+	}
+	return b.String()
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mjl-/mox/adminapi"
+	"github.com/mjl-/mox/admindb"
+	"github.com/mjl-/mox/queue"
+)
+
+// remoteURL is the URL of the admin API of a remote mox instance, set with the
+// -remote flag. If set, commands that support it call the admin API instead of
+// using the local control socket.
+var remoteURL string
+
+// remoteCommands are the commands that can be run with -remote, through the
+// admin API. Other commands need local access.
+var remoteCommands = []string{
+	"config domain add",
+	"config domain rm",
+	"config account add",
+	"config account rm",
+	"config address add",
+	"config address rm",
+	"config alias list",
+	"config alias print",
+	"config alias add",
+	"config alias update",
+	"config alias rm",
+	"config alias addaddr",
+	"config alias rmaddr",
+	"config apply",
+	"setaccountpassword",
+	"loglevels",
+	"queue list",
+	"queue hold",
+	"queue unhold",
+	"queue schedule",
+	"queue drop",
+	"usage",
+}
+
+// xremote returns a client for the admin API of the remote mox instance, or nil
+// if no remote instance is configured. The API token is read from $MOXTOKEN.
+func xremote() *adminapi.Client {
+	if remoteURL == "" {
+		return nil
+	}
+	token := os.Getenv("MOXTOKEN")
+	if token == "" {
+		log.Fatalf("-remote requires an admin api token in $MOXTOKEN, see \"mox config apitoken add\"")
+	}
+	baseURL := remoteURL
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+	if !strings.HasSuffix(baseURL, "/v0/") {
+		baseURL += "v0/"
+	}
+	return &adminapi.Client{BaseURL: baseURL, Token: token}
+}
+
+// xremoteQueueFilter converts a filter from command-line flags to a filter for
+// the admin API, which doesn't support all fields.
+func xremoteQueueFilter(f queue.Filter) adminapi.QueueFilter {
+	if f.Submitted != "" || f.NextAttempt != "" || f.Transport != nil {
+		log.Fatalf("filters -submitted, -nextattempt and -transport are not available with -remote")
+	}
+	return adminapi.QueueFilter{IDs: f.IDs, Account: f.Account, From: f.From, To: f.To, Hold: f.Hold}
+}
+
+func remotecmdSetaccountpassword(rc *adminapi.Client, account, password string) {
+	_, err := rc.AccountPasswordSet(context.Background(), adminapi.AccountPasswordSetRequest{Account: account, Password: password})
+	xcheckf(err, "setting password")
+}
+
+func remotecmdLoglevels(rc *adminapi.Client) {
+	resp, err := rc.LogLevels(context.Background(), adminapi.LogLevelsRequest{})
+	xcheckf(err, "listing log levels")
+	var pkgs []string
+	for pkg := range resp.Levels {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Strings(pkgs)
+	for _, pkg := range pkgs {
+		name := pkg
+		if name == "" {
+			name = "(default)"
+		}
+		fmt.Printf("%s: %s\n", name, resp.Levels[pkg])
+	}
+}
+
+func remotecmdSetLoglevels(rc *adminapi.Client, pkg, level string) {
+	_, err := rc.LogLevelSet(context.Background(), adminapi.LogLevelSetRequest{Pkg: pkg, Level: level})
+	xcheckf(err, "setting log level")
+}
+
+func remotecmdQueueList(rc *adminapi.Client, f queue.Filter, s queue.Sort) {
+	if s.Field != "" || s.Asc {
+		log.Fatalf("sorting is not available with -remote, messages are listed most recently queued first")
+	}
+	resp, err := rc.QueueList(context.Background(), adminapi.QueueListRequest{Filter: xremoteQueueFilter(f), Max: f.Max})
+	xcheckf(err, "listing queue")
+	fmt.Println("messages:")
+	for _, qm := range resp.Messages {
+		var lastAttempt string
+		if qm.LastAttempt != nil {
+			lastAttempt = time.Since(*qm.LastAttempt).Round(time.Second).String()
+		}
+		fmt.Printf("%5d %s from:%s to:%s next %s last %s error %q\n", qm.ID, qm.Queued.Format(time.RFC3339), qm.From, qm.To, -time.Since(qm.NextAttempt).Round(time.Second), lastAttempt, qm.LastError)
+	}
+	if len(resp.Messages) == 0 {
+		fmt.Println("(none)")
+	}
+}
+
+func remotecmdQueueHoldSet(rc *adminapi.Client, f queue.Filter, hold bool) {
+	if f.Max != 0 {
+		log.Fatalf("-n is not available with -remote")
+	}
+	resp, err := rc.QueueHoldSet(context.Background(), adminapi.QueueHoldSetRequest{Filter: xremoteQueueFilter(f), Hold: hold})
+	xcheckf(err, "changing hold")
+	fmt.Printf("%d messages changed\n", resp.Affected)
+}
+
+func remotecmdQueueSchedule(rc *adminapi.Client, f queue.Filter, fromNow bool, d time.Duration) {
+	if !fromNow || d != 0 {
+		log.Fatalf(`only immediate delivery with "queue schedule -now 0" is available with -remote`)
+	}
+	if f.Max != 0 {
+		log.Fatalf("-n is not available with -remote")
+	}
+	resp, err := rc.QueueKick(context.Background(), adminapi.QueueKickRequest{Filter: xremoteQueueFilter(f)})
+	xcheckf(err, "scheduling messages")
+	fmt.Printf("%d message(s) rescheduled\n", resp.Affected)
+}
+
+func remotecmdQueueDrop(rc *adminapi.Client, f queue.Filter) {
+	if f.Max != 0 {
+		log.Fatalf("-n is not available with -remote")
+	}
+	resp, err := rc.QueueDrop(context.Background(), adminapi.QueueDropRequest{Filter: xremoteQueueFilter(f)})
+	xcheckf(err, "dropping messages")
+	fmt.Printf("%d message(s) dropped\n", resp.Affected)
+}
+
+func remotecmdConfigDomainAdd(rc *adminapi.Client, domain, account, localpart string) {
+	_, err := rc.DomainAdd(context.Background(), adminapi.DomainAddRequest{Domain: domain, Account: account, Localpart: localpart})
+	xcheckf(err, "adding domain")
+	fmt.Printf("domain added, remember to add dns records, see the admin web interface\n")
+}
+
+func remotecmdConfigDomainRemove(rc *adminapi.Client, domain string) {
+	_, err := rc.DomainRemove(context.Background(), adminapi.DomainRemoveRequest{Domain: domain})
+	xcheckf(err, "removing domain")
+	fmt.Printf("domain removed, remember to remove dns records for %s\n", domain)
+}
+
+func remotecmdConfigAccountAdd(rc *adminapi.Client, account, address string) {
+	_, err := rc.AccountAdd(context.Background(), adminapi.AccountAddRequest{Account: account, Address: address})
+	xcheckf(err, "adding account")
+	fmt.Printf("account added, set a password with \"mox -remote %s setaccountpassword %s\"\n", remoteURL, account)
+}
+
+func remotecmdConfigAccountRemove(rc *adminapi.Client, account string) {
+	_, err := rc.AccountRemove(context.Background(), adminapi.AccountRemoveRequest{Account: account})
+	xcheckf(err, "removing account")
+	fmt.Println("account removed")
+}
+
+func remotecmdConfigAddressAdd(rc *adminapi.Client, address, account string) {
+	_, err := rc.AddressAdd(context.Background(), adminapi.AddressAddRequest{Address: address, Account: account})
+	xcheckf(err, "adding address")
+	fmt.Println("address added")
+}
+
+func remotecmdConfigAddressRemove(rc *adminapi.Client, address string) {
+	_, err := rc.AddressRemove(context.Background(), adminapi.AddressRemoveRequest{Address: address})
+	xcheckf(err, "removing address")
+	fmt.Println("address removed")
+}
+
+func remotecmdConfigAliasList(rc *adminapi.Client, domain string) {
+	resp, err := rc.AliasList(context.Background(), adminapi.AliasListRequest{Domain: domain})
+	xcheckf(err, "listing aliases")
+	for _, a := range resp.Aliases {
+		fmt.Println(a.Address)
+	}
+}
+
+func remotecmdConfigAliasPrint(rc *adminapi.Client, address string) {
+	resp, err := rc.AliasGet(context.Background(), adminapi.AliasGetRequest{Address: address})
+	xcheckf(err, "looking up alias")
+	fmt.Printf("# postpublic %v\n", resp.Alias.PostPublic)
+	fmt.Printf("# listmembers %v\n", resp.Alias.ListMembers)
+	fmt.Printf("# allowmsgfrom %v\n", resp.Alias.AllowMsgFrom)
+	fmt.Println("# members:")
+	for _, m := range resp.Alias.Members {
+		fmt.Println(m)
+	}
+}
+
+func remotecmdConfigAliasAdd(rc *adminapi.Client, address string, members []string) {
+	_, err := rc.AliasAdd(context.Background(), adminapi.AliasAddRequest{Alias: adminapi.Alias{Address: address, Members: members}})
+	xcheckf(err, "adding alias")
+}
+
+func remotecmdConfigAliasUpdate(rc *adminapi.Client, alias, postpublic, listmembers, allowmsgfrom string) {
+	req := adminapi.AliasUpdateRequest{Address: alias}
+	xbool := func(s, flag string) *bool {
+		switch s {
+		case "":
+			return nil
+		case "true", "false":
+			v := s == "true"
+			return &v
+		}
+		log.Fatalf("bad value %q for -%s, need true or false", s, flag)
+		return nil
+	}
+	req.PostPublic = xbool(postpublic, "postpublic")
+	req.ListMembers = xbool(listmembers, "listmembers")
+	req.AllowMsgFrom = xbool(allowmsgfrom, "allowmsgfrom")
+	_, err := rc.AliasUpdate(context.Background(), req)
+	xcheckf(err, "updating alias")
+}
+
+func remotecmdConfigAliasRemove(rc *adminapi.Client, alias string) {
+	_, err := rc.AliasRemove(context.Background(), adminapi.AliasRemoveRequest{Address: alias})
+	xcheckf(err, "removing alias")
+}
+
+func remotecmdConfigAliasAddaddr(rc *adminapi.Client, alias string, addresses []string) {
+	_, err := rc.AliasMembersAdd(context.Background(), adminapi.AliasMembersAddRequest{Address: alias, Members: addresses})
+	xcheckf(err, "adding addresses to alias")
+}
+
+func remotecmdConfigAliasRmaddr(rc *adminapi.Client, alias string, addresses []string) {
+	_, err := rc.AliasMembersRemove(context.Background(), adminapi.AliasMembersRemoveRequest{Address: alias, Members: addresses})
+	xcheckf(err, "removing addresses from alias")
+}
+
+func remotecmdConfigApply(rc *adminapi.Client, buf []byte, dryRun bool) {
+	resp, err := rc.ConfigApply(context.Background(), adminapi.ConfigApplyRequest{Config: string(buf), DryRun: dryRun})
+	xcheckf(err, "applying config")
+	if len(resp.Changes) == 0 {
+		fmt.Println("no changes")
+	}
+	for _, c := range resp.Changes {
+		s := c.Action + " " + c.Kind
+		if c.Name != "" {
+			s += " " + c.Name
+		}
+		fmt.Println(s)
+	}
+	if len(resp.Changes) > 0 && dryRun {
+		fmt.Println("dry run, not applied")
+	}
+}
+
+func remotecmdUsage(rc *adminapi.Client, f admindb.UsageFilter, group, format string) {
+	resp, err := rc.UsageList(context.Background(), adminapi.UsageListRequest{Start: f.Start, End: f.End, Account: f.Account, Domain: f.Domain, Group: group})
+	xcheckf(err, "listing usage")
+	var l []admindb.Usage
+	for _, u := range resp.Usage {
+		l = append(l, admindb.Usage{
+			Month:            u.Month,
+			Account:          u.Account,
+			Domain:           u.Domain,
+			MessagesReceived: u.MessagesReceived,
+			BytesReceived:    u.BytesReceived,
+			MessagesSent:     u.MessagesSent,
+			BytesSent:        u.BytesSent,
+			StoredBytes:      u.StoredBytes,
+		})
+	}
+	err = usageWrite(os.Stdout, l, format)
+	xcheckf(err, "writing usage")
+}
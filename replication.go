@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mjl-/mox/replication"
+)
+
+func cmdReplicationStandby(c *cmd) {
+	c.params = "[-name name] [-interval duration] -tokenfile file primary-url data-dir"
+	c.help = `Replicate the data directory of a primary mox to a local data directory.
+
+The primary must have Replication configured in mox.conf, and a listener with
+ReplicationHTTPS enabled. The primary-url is the URL of that endpoint, e.g.
+https://mail.example.org/replication/. The token file must contain the same
+token as configured on the primary.
+
+The standby keeps running. The primary holds requests of the standby until
+something changes, so changes are replicated as they happen. Of databases that
+changed, only the changed blocks are transferred, and the standby verifies the
+resulting copy against a checksum of the consistent database on the primary.
+New message files are copied, and message files that were rewritten on the
+primary, e.g. by "mox compressmessages", are copied again. Synchronizations
+start at most once per interval.
+
+The standby does not need a mox.conf, and must not run "mox serve" on the data
+directory while replicating. Message files encrypted at rest on the primary are
+copied as is, the standby needs the same master key to read them after a
+failover. Deduplicated message files are copied as separate files.
+
+The state of the replication, including the time of the last successful sync,
+is kept in the file replication.json in the data directory. See "mox
+replication status".
+
+Failover, when the primary is lost:
+
+1. Stop "mox replication standby".
+2. Check when the last sync completed with "mox replication status data-dir".
+   Changes made on the primary after that are lost.
+3. Put the mox.conf and domains.conf of the primary in place, with DataDir
+   pointing to the replicated data directory. The configuration files are not
+   replicated, keep a copy of them on the standby, and update it when the
+   configuration of the primary changes. Adjust IPs in the listeners if needed.
+4. Run "mox verifydata data-dir", and start mox with "mox serve".
+5. Point DNS records for the mail host name (A/AAAA) to the standby, or move
+   the IP address of the primary to the standby. If IPs change, update SPF
+   records, and keep in mind the reputation of the new IP for outgoing email.
+6. When the old primary comes back, do not start it as is: it would deliver
+   messages from its queue again. Set it up as a standby of the new primary
+   with an empty data directory instead.
+`
+	var name, tokenFile string
+	interval := time.Second
+	c.flag.StringVar(&name, "name", "", "name of standby, shown in status on primary; default is the hostname")
+	c.flag.StringVar(&tokenFile, "tokenfile", "", "file with token for authenticating to primary")
+	c.flag.DurationVar(&interval, "interval", interval, "minimum interval between synchronizations, and delay before retrying after an error")
+	args := c.Parse()
+	if len(args) != 2 || tokenFile == "" {
+		c.Usage()
+	}
+
+	buf, err := os.ReadFile(tokenFile)
+	xcheckf(err, "reading token file")
+	if name == "" {
+		name, err = os.Hostname()
+		xcheckf(err, "get hostname")
+	}
+	if _, err := os.Stat(filepath.Join(args[1], "ctl")); err == nil {
+		log.Printf("warning: data directory has a ctl file, make sure mox is not running with this data directory")
+	}
+
+	s := replication.Standby{
+		URL:     args[0],
+		Token:   strings.TrimSpace(string(buf)),
+		Name:    name,
+		DataDir: args[1],
+		Client:  &http.Client{Transport: http.DefaultTransport},
+	}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	s.Run(ctx, c.log, interval)
+}
+
+func cmdReplicationStatus(c *cmd) {
+	c.params = "[standby-data-dir]"
+	c.help = `Show the status of replication.
+
+Without parameter, the standbys of the running mox instance are listed, with
+the time of their last completed synchronization. Standbys are only known
+after their first request since mox was started.
+
+With a data directory of a standby as parameter, the replication state of that
+standby is printed, including the lag: how long ago the changes it has were
+made on the primary.
+`
+	args := c.Parse()
+	if len(args) > 1 {
+		c.Usage()
+	}
+	if len(args) == 1 {
+		st, err := replication.ReadState(args[0])
+		xcheckf(err, "reading replication state")
+		fmt.Printf("primary: %s\n", st.Primary)
+		if st.LastSync.IsZero() {
+			fmt.Printf("last sync: (none)\n")
+		} else {
+			fmt.Printf("last sync: %s, lag %s\n", st.LastSync.Format(time.RFC3339), time.Since(st.LastManifest).Round(time.Second))
+		}
+		fmt.Printf("files: %d\n", len(st.Versions))
+		if st.LastError != "" {
+			fmt.Printf("last error: %s: %s\n", st.LastErrorTime.Format(time.RFC3339), st.LastError)
+		}
+		return
+	}
+
+	mustLoadConfig()
+	ctlcmdReplicationStatus(xctl())
+}
+
+func ctlcmdReplicationStatus(ctl *ctl) {
+	ctl.xwrite("replicationstatus")
+	ctl.xreadok()
+	ctl.xstreamto(os.Stdout)
+}
@@ -0,0 +1,597 @@
+// Package replication copies the data directory of a primary mox instance to
+// standby instances, for failover when the primary is lost.
+//
+// The primary serves replication requests over HTTPS on listeners with
+// ReplicationHTTPS enabled, authenticated with a shared token. A standby,
+// running "mox replication standby", requests a manifest of the databases and
+// other files in the data directory of the primary, with a version for each
+// file. The request is held by the primary until a file changes, so changes are
+// pushed to the standby as they happen, within a fraction of a second.
+//
+// Databases that changed are transferred as deltas: the standby sends hashes of
+// the blocks of its copy of the database, and the primary writes the database in
+// a read-only transaction, so the copy is consistent, sending only the blocks
+// that differ. Databases are copy-on-write with fixed-size pages, so a delivered
+// message or changed flag only changes a few blocks. A checksum of the whole new
+// database is verified by the standby.
+//
+// After copying the index database of an account or the queue, the standby
+// fetches the message files referenced by the new copy that it doesn't have yet,
+// and removes message files that are no longer referenced. Message files can be
+// rewritten on the primary, e.g. when compressed by "mox compressmessages". The
+// standby compares the format and checksum of each message between its previous
+// copy of the index database and the new copy, and fetches the message file
+// again if they changed. The new copy of the index database only replaces the
+// previous copy when all message files have been fetched.
+//
+// The state of a standby is stored in the file "replication.json" in its data
+// directory. The primary keeps track of when each standby last completed a
+// sync, for "mox replication status".
+package replication
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/admindb"
+	"github.com/mjl-/mox/dmarcdb"
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/moxio"
+	"github.com/mjl-/mox/mtastsdb"
+	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/store"
+	"github.com/mjl-/mox/tlsrptdb"
+	"github.com/mjl-/mox/webauth"
+)
+
+var pkglog = mlog.New("replication", nil)
+
+var (
+	metricRequests = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mox_replication_requests_total",
+			Help: "Replication requests from standbys by kind and result.",
+		},
+		[]string{"kind", "result"}, // kind: manifest, file, db, message; result: ok, badauth, notfound, error
+	)
+	metricBytes = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "mox_replication_sent_bytes_total",
+			Help: "Bytes of databases, files and messages sent to standbys.",
+		},
+	)
+)
+
+// Manifest lists the files in the data directory of the primary that are
+// replicated.
+type Manifest struct {
+	Time  time.Time // Time the manifest was made on the primary.
+	Files []File
+}
+
+// Digest returns a value that changes when the manifest lists different files
+// or versions.
+func (m Manifest) Digest() string {
+	h := sha256.New()
+	for _, f := range m.Files {
+		fmt.Fprintf(h, "%s\x00%s\x00", f.Path, f.Version)
+	}
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// How long the primary holds a manifest request when nothing changed, and how
+// often it checks for changes in the meantime.
+var (
+	manifestWait     = 30 * time.Second
+	manifestInterval = 250 * time.Millisecond
+)
+
+// Databases are transferred in blocks of this size. A standby sends hashes of
+// each block in its copy, truncated to dbHashSize bytes. Pages of databases are
+// 4KB.
+const (
+	dbBlockSize = 16 * 1024
+	dbHashSize  = 16
+)
+
+// Records in a database delta sent by the primary.
+const (
+	deltaSame = 0 // Block is unchanged, the standby reuses its copy.
+	deltaData = 1 // Followed by 4 bytes size and the data of the block.
+	deltaEnd  = 2 // Followed by 8 bytes size of database, and SHA-256 of database.
+)
+
+// File is a replicated file.
+type File struct {
+	Path    string // Relative to data directory, with slashes.
+	DB      bool   // Whether file is a database, copied in a read-only transaction.
+	Version string // Changes when the file changes, from its modification time and size.
+}
+
+// StandbyStatus is the replication status of a standby, as seen by the primary.
+type StandbyStatus struct {
+	Name        string
+	RemoteIP    string
+	LastRequest time.Time // Of last manifest request.
+	LastSynced  time.Time // Time of manifest of last sync completed by the standby. Zero if none yet.
+}
+
+var standbys = struct {
+	sync.Mutex
+	m map[string]StandbyStatus
+}{m: map[string]StandbyStatus{}}
+
+// Status returns the status of standbys that have fetched a manifest since mox
+// was started, sorted by name.
+func Status() []StandbyStatus {
+	standbys.Lock()
+	defer standbys.Unlock()
+	l := make([]StandbyStatus, 0, len(standbys.m))
+	for _, st := range standbys.m {
+		l = append(l, st)
+	}
+	sort.Slice(l, func(i, j int) bool {
+		return l[i].Name < l[j].Name
+	})
+	return l
+}
+
+// globalDBs returns the databases that are not per account, with their path
+// relative to the data directory. Databases that are not opened are skipped.
+func globalDBs() map[string]*bstore.DB {
+	m := map[string]*bstore.DB{}
+	add := func(p string, db *bstore.DB) {
+		if db != nil {
+			m[p] = db
+		}
+	}
+	add("dmarcrpt.db", dmarcdb.ReportsDB)
+	add("dmarceval.db", dmarcdb.EvalDB)
+	add("mtasts.db", mtastsdb.DB)
+	add("tlsrpt.db", tlsrptdb.ReportDB)
+	add("tlsrptresult.db", tlsrptdb.ResultDB)
+	add("admin.db", admindb.DB)
+	add("queue/index.db", queue.DB)
+	return m
+}
+
+// Files that are replicated as is, if present.
+var plainFiles = []string{"receivedid.key", "lastknownversion", "webpush-vapid.key"}
+
+func fileVersion(fi fs.FileInfo) string {
+	return fmt.Sprintf("%d-%d", fi.ModTime().UnixNano(), fi.Size())
+}
+
+// makeManifest returns the current manifest of replicated files.
+func makeManifest() (Manifest, error) {
+	m := Manifest{Time: time.Now()}
+
+	add := func(p string, db bool) error {
+		fi, err := os.Stat(mox.DataDirPath(filepath.FromSlash(p)))
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		m.Files = append(m.Files, File{p, db, fileVersion(fi)})
+		return nil
+	}
+
+	for p := range globalDBs() {
+		if err := add(p, true); err != nil {
+			return Manifest{}, err
+		}
+	}
+	for _, p := range plainFiles {
+		if err := add(p, false); err != nil {
+			return Manifest{}, err
+		}
+	}
+	acmeDir := mox.DataDirPath("acme")
+	err := filepath.WalkDir(acmeDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == acmeDir && errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		return add(path.Join("acme", filepath.ToSlash(p[len(acmeDir)+1:])), false)
+	})
+	if err != nil {
+		return Manifest{}, fmt.Errorf("listing acme files: %v", err)
+	}
+	for _, accName := range mox.Conf.Accounts() {
+		dir := "accounts/" + accName
+		if err := add(dir+"/index.db", true); err != nil {
+			return Manifest{}, err
+		}
+		if err := add(dir+"/junkfilter.db", true); err != nil {
+			return Manifest{}, err
+		}
+		if err := add(dir+"/junkfilter.bloom", false); err != nil {
+			return Manifest{}, err
+		}
+	}
+	sort.Slice(m.Files, func(i, j int) bool {
+		return m.Files[i].Path < m.Files[j].Path
+	})
+	return m, nil
+}
+
+// Handler returns the HTTP handler for replication requests from standbys.
+// Requests must be authenticated with the token configured in
+// Replication.TokenFile.
+func Handler(isForwarded bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log := pkglog.WithContext(r.Context())
+		kind := strings.TrimPrefix(r.URL.Path, "/")
+
+		if mox.Conf.Static.Replication == nil {
+			http.Error(w, "404 - not found - replication not configured", http.StatusNotFound)
+			return
+		}
+		if kind != "manifest" && kind != "file" && kind != "db" && kind != "message" {
+			http.NotFound(w, r)
+			return
+		}
+		if kind == "db" && r.Method != "POST" {
+			http.Error(w, "405 - method not allowed - use post", http.StatusMethodNotAllowed)
+			return
+		} else if kind != "db" && r.Method != "GET" {
+			http.Error(w, "405 - method not allowed - use get", http.StatusMethodNotAllowed)
+			return
+		}
+
+		t0 := time.Now()
+		remoteIP := webauth.RemoteIP(log, isForwarded, r)
+		if remoteIP == nil {
+			http.Error(w, "500 - internal server error - cannot find remote ip", http.StatusInternalServerError)
+			return
+		}
+		if !mox.LimiterFailedAuth.CanAdd(remoteIP, t0, 1) {
+			metrics.AuthenticationRatelimitedInc("replication")
+			http.Error(w, "429 - too many auth attempts", http.StatusTooManyRequests)
+			return
+		}
+		token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(mox.Conf.Static.Replication.Token)) != 1 {
+			mox.LimiterFailedAuth.Add(remoteIP, t0, 1)
+			mox.AutoBanFailure(log, remoteIP, mox.AutoBanAuth)
+			metrics.AuthenticationInc("replication", "bearer", "badcreds")
+			metricRequests.WithLabelValues(kind, "badauth").Inc()
+			log.Debug("bad replication token", slog.Any("remoteip", remoteIP))
+			w.Header().Set("WWW-Authenticate", "Bearer realm=replication")
+			http.Error(w, "401 - unauthorized - use authorization header with bearer token", http.StatusUnauthorized)
+			return
+		}
+		mox.LimiterFailedAuth.Reset(remoteIP, t0)
+
+		q := r.URL.Query()
+		var err error
+		switch kind {
+		case "manifest":
+			err = serveManifest(r, w, q.Get("standby"), remoteIP.String(), q.Get("synced"), q.Get("digest"))
+		case "file":
+			err = serveFile(log, w, q.Get("path"))
+		case "db":
+			err = serveDB(r, log, w, q.Get("path"))
+		case "message":
+			err = serveMessage(w, q.Get("account"), q.Get("id"))
+		}
+		if errors.Is(err, fs.ErrNotExist) {
+			metricRequests.WithLabelValues(kind, "notfound").Inc()
+			http.Error(w, "404 - not found", http.StatusNotFound)
+		} else if err != nil {
+			metricRequests.WithLabelValues(kind, "error").Inc()
+			log.Errorx("replication request", err, slog.String("kind", kind), slog.String("url", r.URL.String()))
+			http.Error(w, "500 - internal server error - "+err.Error(), http.StatusInternalServerError)
+		} else {
+			metricRequests.WithLabelValues(kind, "ok").Inc()
+		}
+	})
+}
+
+// serveManifest writes the manifest. If digest is set and matches the current
+// manifest, the response is held until the manifest changes, or until
+// manifestWait has passed.
+func serveManifest(r *http.Request, w http.ResponseWriter, name, remoteIP, synced, digest string) error {
+	if name == "" {
+		return fmt.Errorf("missing standby name")
+	}
+	m, err := makeManifest()
+	if err != nil {
+		return err
+	}
+
+	standbys.Lock()
+	st := standbys.m[name]
+	st.Name = name
+	st.RemoteIP = remoteIP
+	st.LastRequest = m.Time
+	if synced != "" {
+		if tm, err := time.Parse(time.RFC3339Nano, synced); err == nil {
+			st.LastSynced = tm
+		}
+	}
+	standbys.m[name] = st
+	standbys.Unlock()
+
+	if digest != "" {
+		timer := time.NewTimer(manifestWait)
+		defer timer.Stop()
+		ticker := time.NewTicker(manifestInterval)
+		defer ticker.Stop()
+	wait:
+		for m.Digest() == digest {
+			select {
+			case <-r.Context().Done():
+				return r.Context().Err()
+			case <-timer.C:
+				break wait
+			case <-ticker.C:
+			}
+			if m, err = makeManifest(); err != nil {
+				return err
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(m)
+}
+
+// lookupFile returns the file with path p from the current manifest.
+func lookupFile(p string) (File, error) {
+	m, err := makeManifest()
+	if err != nil {
+		return File{}, err
+	}
+	for _, f := range m.Files {
+		if f.Path == p {
+			return f, nil
+		}
+	}
+	return File{}, fs.ErrNotExist
+}
+
+// serveFile writes a file that is not a database.
+func serveFile(log mlog.Log, w http.ResponseWriter, p string) error {
+	if f, err := lookupFile(p); err != nil {
+		return err
+	} else if f.DB {
+		return fmt.Errorf("file is a database, use db request")
+	}
+
+	src, err := os.Open(mox.DataDirPath(filepath.FromSlash(p)))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	n, err := io.Copy(w, src)
+	metricBytes.Add(float64(n))
+	if err != nil {
+		abort(log, err)
+	}
+	return nil
+}
+
+// serveDB writes a delta of a database to the copy of the standby, with the
+// hashes of the blocks of the copy in the request body.
+func serveDB(r *http.Request, log mlog.Log, w http.ResponseWriter, p string) error {
+	if f, err := lookupFile(p); err != nil {
+		return err
+	} else if !f.DB {
+		return fmt.Errorf("file is not a database, use file request")
+	}
+
+	hashes, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 64*1024*1024))
+	if err != nil {
+		return fmt.Errorf("reading block hashes: %v", err)
+	} else if len(hashes)%dbHashSize != 0 {
+		return fmt.Errorf("bad size of block hashes")
+	}
+
+	db := globalDBs()[p]
+	var closeDB func()
+	if db == nil {
+		// Per-account database.
+		t := strings.Split(p, "/")
+		if len(t) != 3 || t[0] != "accounts" {
+			return fs.ErrNotExist
+		}
+		acc, err := store.OpenAccount(log, t[1])
+		if err != nil {
+			return fmt.Errorf("open account: %v", err)
+		}
+		defer func() {
+			err := acc.Close()
+			log.Check(err, "closing account after replication")
+		}()
+		switch t[2] {
+		case "index.db":
+			db = acc.DB
+		case "junkfilter.db":
+			jf, _, err := acc.OpenJunkFilter(r.Context(), log)
+			if errors.Is(err, store.ErrNoJunkFilter) {
+				return fs.ErrNotExist
+			} else if err != nil {
+				return fmt.Errorf("open junk filter: %v", err)
+			}
+			db = jf.DB()
+			closeDB = func() {
+				err := jf.Close()
+				log.Check(err, "closing junk filter after replication")
+			}
+		default:
+			return fs.ErrNotExist
+		}
+	}
+	if closeDB != nil {
+		defer closeDB()
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	err = db.Read(r.Context(), func(tx *bstore.Tx) error {
+		dw := &deltaWriter{w: bufio.NewWriter(w), hashes: hashes, h: sha256.New()}
+		if _, err := tx.WriteTo(dw); err != nil {
+			return err
+		}
+		return dw.finish()
+	})
+	if err != nil {
+		abort(log, err)
+	}
+	return nil
+}
+
+// deltaWriter writes a delta of the database written to it, compared to the
+// block hashes of the copy of a standby.
+type deltaWriter struct {
+	w      *bufio.Writer
+	hashes []byte    // Of copy of standby, dbHashSize bytes per block.
+	h      hash.Hash // Of whole database.
+	buf    []byte    // Pending data of current block.
+	block  int       // Index of current block.
+	size   int64
+}
+
+func (dw *deltaWriter) Write(buf []byte) (int, error) {
+	n := len(buf)
+	for len(buf) > 0 {
+		if dw.buf == nil {
+			dw.buf = make([]byte, 0, dbBlockSize)
+		}
+		o := min(len(buf), dbBlockSize-len(dw.buf))
+		dw.buf = append(dw.buf, buf[:o]...)
+		buf = buf[o:]
+		if len(dw.buf) == dbBlockSize {
+			if err := dw.flush(); err != nil {
+				return n - len(buf), err
+			}
+		}
+	}
+	return n, nil
+}
+
+// flush writes the current block, as reference to the copy of the standby if its
+// hash matches.
+func (dw *deltaWriter) flush() error {
+	dw.h.Write(dw.buf)
+	dw.size += int64(len(dw.buf))
+	sum := sha256.Sum256(dw.buf)
+	o := dw.block * dbHashSize
+	var n int64
+	if o+dbHashSize <= len(dw.hashes) && bytes.Equal(dw.hashes[o:o+dbHashSize], sum[:dbHashSize]) {
+		if err := dw.w.WriteByte(deltaSame); err != nil {
+			return err
+		}
+		n = 1
+	} else {
+		var hdr [5]byte
+		hdr[0] = deltaData
+		binary.BigEndian.PutUint32(hdr[1:], uint32(len(dw.buf)))
+		if _, err := dw.w.Write(hdr[:]); err != nil {
+			return err
+		}
+		if _, err := dw.w.Write(dw.buf); err != nil {
+			return err
+		}
+		n = int64(len(hdr) + len(dw.buf))
+	}
+	metricBytes.Add(float64(n))
+	dw.buf = dw.buf[:0]
+	dw.block++
+	return nil
+}
+
+// finish writes the last partial block, the end record and flushes the output.
+func (dw *deltaWriter) finish() error {
+	if len(dw.buf) > 0 {
+		if err := dw.flush(); err != nil {
+			return err
+		}
+	}
+	var end [1 + 8]byte
+	end[0] = deltaEnd
+	binary.BigEndian.PutUint64(end[1:], uint64(dw.size))
+	if _, err := dw.w.Write(end[:]); err != nil {
+		return err
+	}
+	if _, err := dw.w.Write(dw.h.Sum(nil)); err != nil {
+		return err
+	}
+	return dw.w.Flush()
+}
+
+// abort aborts the response after data may have been written, so the standby
+// sees an error instead of a truncated file.
+func abort(log mlog.Log, err error) {
+	if !moxio.IsClosed(err) {
+		log.Errorx("writing replication response", err)
+	}
+	panic(http.ErrAbortHandler)
+}
+
+func serveMessage(w http.ResponseWriter, account, id string) error {
+	msgID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil || msgID <= 0 {
+		return fmt.Errorf("bad message id")
+	}
+	var p string
+	if account == "" {
+		p = mox.DataDirPath(filepath.Join("queue", store.MessagePath(msgID)))
+	} else if _, ok := mox.Conf.Account(account); !ok {
+		return fs.ErrNotExist
+	} else {
+		p = mox.DataDirPath(filepath.Join("accounts", account, "msg", store.MessagePath(msgID)))
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", fi.Size()))
+	n, err := io.Copy(w, f)
+	metricBytes.Add(float64(n))
+	if err != nil {
+		abort(pkglog, err)
+	}
+	return nil
+}
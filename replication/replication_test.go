@@ -0,0 +1,161 @@
+package replication
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/store"
+)
+
+var ctxbg = context.Background()
+
+func tcheck(t *testing.T, err error, msg string) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %s", msg, err)
+	}
+}
+
+func tcompare(t *testing.T, got, exp any) {
+	t.Helper()
+	if got != exp {
+		t.Fatalf("got %v, expected %v", got, exp)
+	}
+}
+
+func TestReplication(t *testing.T) {
+	log := pkglog
+	os.RemoveAll("../testdata/replication/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/replication/mox.conf")
+	mox.MustLoadConfig(true, false)
+	mox.LimitersInit()
+	defer store.Switchboard()()
+	acc, err := store.OpenAccount(log, "mjl")
+	tcheck(t, err, "open account")
+	defer func() {
+		err := acc.Close()
+		tcheck(t, err, "closing account")
+		acc.CheckClosed()
+	}()
+
+	const msg = "Subject: replicated\r\n\r\nbody\r\n"
+	deliver := func() store.Message {
+		t.Helper()
+		msgFile, err := store.CreateMessageTemp(log, "replication-test")
+		tcheck(t, err, "create temp message")
+		defer os.Remove(msgFile.Name())
+		defer msgFile.Close()
+		_, err = msgFile.Write([]byte(msg))
+		tcheck(t, err, "write message")
+		m := store.Message{Received: time.Now(), Size: int64(len(msg))}
+		acc.WithWLock(func() {
+			err = acc.DeliverMailbox(log, "Inbox", &m, msgFile)
+		})
+		tcheck(t, err, "deliver message")
+		return m
+	}
+	m0 := deliver()
+
+	srv := httptest.NewServer(Handler(false))
+	defer srv.Close()
+
+	dataDir := filepath.FromSlash("../testdata/replication/data/standby")
+	standby := Standby{
+		URL:     srv.URL + "/",
+		Token:   "bogus",
+		Name:    "test",
+		DataDir: dataDir,
+		Client:  srv.Client(),
+	}
+	_, err = standby.Sync(ctxbg, log)
+	if err == nil {
+		t.Fatalf("sync with bad token succeeded")
+	}
+
+	standby.Token = mox.Conf.Static.Replication.Token
+	stats, err := standby.Sync(ctxbg, log)
+	tcheck(t, err, "sync")
+	tcompare(t, stats.Messages, 1)
+	buf, err := os.ReadFile(filepath.Join(dataDir, "accounts", "mjl", "msg", store.MessagePath(m0.ID)))
+	tcheck(t, err, "read replicated message")
+	tcompare(t, string(buf), msg)
+	_, err = os.Stat(filepath.Join(dataDir, "accounts", "mjl", "index.db"))
+	tcheck(t, err, "stat replicated database")
+
+	l := Status()
+	tcompare(t, len(l), 1)
+	tcompare(t, l[0].Name, "test")
+	tcompare(t, l[0].LastSynced.IsZero(), true)
+
+	// Nothing changed, nothing is copied.
+	stats, err = standby.Sync(ctxbg, log)
+	tcheck(t, err, "sync")
+	tcompare(t, stats, SyncStats{})
+	tcompare(t, Status()[0].LastSynced.IsZero(), false)
+
+	// Only the new message file is copied.
+	m1 := deliver()
+	stats, err = standby.Sync(ctxbg, log)
+	tcheck(t, err, "sync")
+	tcompare(t, stats.Messages, 1)
+	_, err = os.Stat(filepath.Join(dataDir, "accounts", "mjl", "msg", store.MessagePath(m1.ID)))
+	tcheck(t, err, "stat replicated message")
+
+	// Only changed blocks of the database are transferred.
+	fi, err := os.Stat(filepath.Join(dataDir, "accounts", "mjl", "index.db"))
+	tcheck(t, err, "stat replicated database")
+	if stats.Bytes > fi.Size()/2 {
+		t.Fatalf("transferred %d bytes for database of %d bytes, expected delta", stats.Bytes, fi.Size())
+	}
+
+	// Message files rewritten on the primary are fetched again.
+	mox.Conf.Static.CompressMessages = true
+	defer func() {
+		mox.Conf.Static.CompressMessages = false
+	}()
+	_, err = acc.CompressMessageFiles(ctxbg, log)
+	tcheck(t, err, "compress message files")
+	stats, err = standby.Sync(ctxbg, log)
+	tcheck(t, err, "sync")
+	tcompare(t, stats.Messages, 2)
+	for _, m := range []store.Message{m0, m1} {
+		buf, err := os.ReadFile(filepath.Join(dataDir, "accounts", "mjl", "msg", store.MessagePath(m.ID)))
+		tcheck(t, err, "read replicated message")
+		pbuf, err := os.ReadFile(acc.MessagePath(m.ID))
+		tcheck(t, err, "read message")
+		tcompare(t, string(buf), string(pbuf))
+	}
+
+	// With waiting, the primary holds the request until something changes.
+	standby.Wait = true
+	manifestWait = 100 * time.Millisecond
+	t0 := time.Now()
+	stats, err = standby.Sync(ctxbg, log)
+	tcheck(t, err, "sync")
+	tcompare(t, stats, SyncStats{})
+	if time.Since(t0) < manifestWait {
+		t.Fatalf("primary did not hold request")
+	}
+	manifestWait = 10 * time.Second
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		deliver()
+	}()
+	t0 = time.Now()
+	stats, err = standby.Sync(ctxbg, log)
+	tcheck(t, err, "sync")
+	tcompare(t, stats.Messages, 1)
+	if time.Since(t0) >= manifestWait {
+		t.Fatalf("primary did not respond to change")
+	}
+
+	st, err := ReadState(dataDir)
+	tcheck(t, err, "read state")
+	tcompare(t, st.Primary, standby.URL)
+	tcompare(t, st.LastError, "")
+}
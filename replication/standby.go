@@ -0,0 +1,565 @@
+package replication
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/store"
+)
+
+// StateFile is the name of the file in the data directory of a standby with its
+// replication state.
+const StateFile = "replication.json"
+
+// State is the replication state of a standby.
+type State struct {
+	Primary       string            // URL of replication endpoint of primary.
+	Versions      map[string]string // Path to version of copied files.
+	LastManifest  time.Time         // Time of manifest on primary of last completed sync.
+	LastDigest    string            // Digest of manifest of last completed sync, for waiting for changes on the primary.
+	LastSync      time.Time         // Local time of last completed sync.
+	LastError     string            // Of last sync, if it failed.
+	LastErrorTime time.Time
+}
+
+// ReadState reads the replication state from the data directory of a standby.
+func ReadState(dataDir string) (State, error) {
+	var st State
+	buf, err := os.ReadFile(filepath.Join(dataDir, StateFile))
+	if err != nil {
+		return st, err
+	}
+	err = json.Unmarshal(buf, &st)
+	return st, err
+}
+
+func writeState(dataDir string, st State) error {
+	buf, err := json.MarshalIndent(st, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(dataDir, StateFile), strings.NewReader(string(buf)))
+}
+
+// writeFile writes the contents of r to a temporary file next to path, and
+// renames it into place after syncing.
+func writeFile(p string, r io.Reader) (rerr error) {
+	if err := os.MkdirAll(filepath.Dir(p), 0770); err != nil {
+		return err
+	}
+	tmp := p + ".replication"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0660)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+		if rerr != nil {
+			os.Remove(tmp)
+		}
+	}()
+	if _, err := io.Copy(f, r); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	err = f.Close()
+	f = nil
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+// Standby replicates the data directory of a primary to a local data directory.
+type Standby struct {
+	URL     string // Replication endpoint of primary, e.g. https://mail.example.org/replication/.
+	Token   string // Shared secret, as configured on the primary.
+	Name    string // Name of the standby, shown in the status on the primary.
+	DataDir string // Local data directory to replicate to.
+	Client  *http.Client
+	Wait    bool // Whether the primary should hold requests for a manifest until something changed.
+}
+
+// SyncStats holds the number of changes made by a sync.
+type SyncStats struct {
+	Files    int   // Databases and other files copied.
+	Messages int   // Message files copied.
+	Removed  int   // Files removed.
+	Bytes    int64 // Transferred.
+}
+
+func (s Standby) get(ctx context.Context, kind string, params url.Values) (*http.Response, error) {
+	return s.do(ctx, "GET", kind, params, nil)
+}
+
+func (s Standby) do(ctx context.Context, method, kind string, params url.Values, body io.Reader) (*http.Response, error) {
+	u := strings.TrimSuffix(s.URL, "/") + "/" + kind + "?" + params.Encode()
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		buf, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, fs.ErrNotExist
+		}
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(buf)))
+	}
+	return resp, nil
+}
+
+// fetch retrieves a file from the primary, writing it to p.
+func (s Standby) fetch(ctx context.Context, kind string, params url.Values, p string, stats *SyncStats) error {
+	resp, err := s.get(ctx, kind, params)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	cr := &countReader{r: resp.Body}
+	err = writeFile(p, cr)
+	stats.Bytes += cr.n
+	return err
+}
+
+// fetchDB retrieves a database from the primary as a delta to the copy at p, if
+// any, writing the new copy to newp.
+func (s Standby) fetchDB(ctx context.Context, path, p, newp string, stats *SyncStats) error {
+	var hashes bytes.Buffer
+	var prev io.ReaderAt
+	old, err := os.Open(p)
+	if err == nil {
+		defer old.Close()
+		prev = old
+		buf := make([]byte, dbBlockSize)
+		for {
+			n, err := io.ReadFull(old, buf)
+			if n > 0 {
+				sum := sha256.Sum256(buf[:n])
+				hashes.Write(sum[:dbHashSize])
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			} else if err != nil {
+				return fmt.Errorf("reading copy of database: %v", err)
+			}
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("open copy of database: %v", err)
+	}
+
+	resp, err := s.do(ctx, "POST", "db", url.Values{"path": {path}}, &hashes)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	cr := &countReader{r: resp.Body}
+	dr := &deltaReader{r: bufio.NewReader(cr), old: prev, h: sha256.New()}
+	err = writeFile(newp, dr)
+	stats.Bytes += cr.n
+	return err
+}
+
+// deltaReader reads a database from a delta, written by deltaWriter on the
+// primary, and the previous copy.
+type deltaReader struct {
+	r     *bufio.Reader
+	old   io.ReaderAt // Previous copy, can be nil.
+	h     hash.Hash   // Of database read so far.
+	block int64       // Index of next block.
+	size  int64       // Read so far.
+	buf   []byte      // Remaining data of current block.
+	done  bool
+}
+
+func (dr *deltaReader) Read(buf []byte) (int, error) {
+	for len(dr.buf) == 0 {
+		if dr.done {
+			return 0, io.EOF
+		}
+		if err := dr.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(buf, dr.buf)
+	dr.buf = dr.buf[n:]
+	return n, nil
+}
+
+// next reads the next record of the delta.
+func (dr *deltaReader) next() error {
+	kind, err := dr.r.ReadByte()
+	if err != nil {
+		return fmt.Errorf("reading delta: %w", noEOF(err))
+	}
+	var data []byte
+	switch kind {
+	case deltaSame:
+		if dr.old == nil {
+			return fmt.Errorf("delta references block %d, but there is no previous copy", dr.block)
+		}
+		data = make([]byte, dbBlockSize)
+		n, err := dr.old.ReadAt(data, dr.block*dbBlockSize)
+		if err != nil && (err != io.EOF || n == 0) {
+			return fmt.Errorf("reading block %d of previous copy: %v", dr.block, noEOF(err))
+		}
+		data = data[:n]
+	case deltaData:
+		var hdr [4]byte
+		if _, err := io.ReadFull(dr.r, hdr[:]); err != nil {
+			return fmt.Errorf("reading delta: %w", noEOF(err))
+		}
+		n := binary.BigEndian.Uint32(hdr[:])
+		if n == 0 || n > dbBlockSize {
+			return fmt.Errorf("bad block size %d in delta", n)
+		}
+		data = make([]byte, n)
+		if _, err := io.ReadFull(dr.r, data); err != nil {
+			return fmt.Errorf("reading delta: %w", noEOF(err))
+		}
+	case deltaEnd:
+		var end [8 + sha256.Size]byte
+		if _, err := io.ReadFull(dr.r, end[:]); err != nil {
+			return fmt.Errorf("reading delta: %w", noEOF(err))
+		}
+		if size := int64(binary.BigEndian.Uint64(end[:8])); size != dr.size {
+			return fmt.Errorf("database from delta has size %d, expected %d", dr.size, size)
+		} else if !bytes.Equal(dr.h.Sum(nil), end[8:]) {
+			return fmt.Errorf("checksum mismatch for database from delta")
+		}
+		dr.done = true
+		return nil
+	default:
+		return fmt.Errorf("bad record %d in delta", kind)
+	}
+	dr.h.Write(data)
+	dr.size += int64(len(data))
+	dr.block++
+	dr.buf = data
+	return nil
+}
+
+// noEOF turns an EOF into an unexpected EOF, for truncated data.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countReader) Read(buf []byte) (int, error) {
+	n, err := c.r.Read(buf)
+	c.n += int64(n)
+	return n, err
+}
+
+// Sync does a single synchronization with the primary. Progress is stored in
+// the state file, also when an error occurs, so the next sync continues where
+// this one stopped.
+func (s Standby) Sync(ctx context.Context, log mlog.Log) (stats SyncStats, rerr error) {
+	st, err := ReadState(s.DataDir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return stats, fmt.Errorf("reading state: %v", err)
+	}
+	if st.Primary != s.URL {
+		// Different primary, start over, without removing files that are still needed.
+		st = State{Primary: s.URL}
+	}
+	if st.Versions == nil {
+		st.Versions = map[string]string{}
+	}
+	defer func() {
+		if rerr != nil {
+			st.LastError = rerr.Error()
+			st.LastErrorTime = time.Now()
+		}
+		err := writeState(s.DataDir, st)
+		if err != nil && rerr == nil {
+			rerr = fmt.Errorf("writing state: %v", err)
+		}
+	}()
+
+	params := url.Values{"standby": {s.Name}}
+	if !st.LastManifest.IsZero() {
+		params.Set("synced", st.LastManifest.Format(time.RFC3339Nano))
+	}
+	if s.Wait && st.LastDigest != "" {
+		// Primary holds the request until something changed.
+		params.Set("digest", st.LastDigest)
+	}
+	resp, err := s.get(ctx, "manifest", params)
+	if err != nil {
+		return stats, fmt.Errorf("fetching manifest: %v", err)
+	}
+	var m Manifest
+	err = json.NewDecoder(resp.Body).Decode(&m)
+	resp.Body.Close()
+	if err != nil {
+		return stats, fmt.Errorf("parsing manifest: %v", err)
+	}
+
+	present := map[string]struct{}{}
+	for _, f := range m.Files {
+		present[f.Path] = struct{}{}
+		if !validPath(f.Path) {
+			return stats, fmt.Errorf("bad path %q in manifest", f.Path)
+		}
+		if st.Versions[f.Path] == f.Version {
+			continue
+		}
+
+		p := filepath.Join(s.DataDir, filepath.FromSlash(f.Path))
+		if !f.DB {
+			err := s.fetch(ctx, "file", url.Values{"path": {f.Path}}, p, &stats)
+			if errors.Is(err, fs.ErrNotExist) {
+				// Removed since manifest was made.
+				continue
+			} else if err != nil {
+				return stats, fmt.Errorf("fetching %s: %v", f.Path, err)
+			}
+			stats.Files++
+			log.Debug("replicated file", slog.String("path", f.Path))
+			st.Versions[f.Path] = f.Version
+			continue
+		}
+
+		newp := p + ".new"
+		err := s.fetchDB(ctx, f.Path, p, newp, &stats)
+		if errors.Is(err, fs.ErrNotExist) {
+			// Removed since manifest was made.
+			continue
+		} else if err != nil {
+			return stats, fmt.Errorf("fetching %s: %v", f.Path, err)
+		}
+		stats.Files++
+		log.Debug("replicated database", slog.String("path", f.Path))
+
+		// Fetch message files referenced by a new account or queue database. The new
+		// copy only replaces the previous copy after all messages are present, the
+		// previous copy is needed to find rewritten message files.
+		if t := strings.Split(f.Path, "/"); f.Path == "queue/index.db" {
+			err = s.syncMessages(ctx, log, "", p, newp, &stats)
+		} else if len(t) == 3 && t[0] == "accounts" && t[2] == "index.db" {
+			err = s.syncMessages(ctx, log, t[1], p, newp, &stats)
+		}
+		if err != nil {
+			return stats, fmt.Errorf("replicating message files for %s: %v", f.Path, err)
+		}
+		if err := os.Rename(newp, p); err != nil {
+			return stats, fmt.Errorf("replacing %s: %v", f.Path, err)
+		}
+		st.Versions[f.Path] = f.Version
+	}
+
+	// Remove files that are gone on the primary, e.g. for removed accounts.
+	for p := range st.Versions {
+		if _, ok := present[p]; ok {
+			continue
+		}
+		lp := filepath.Join(s.DataDir, filepath.FromSlash(p))
+		if t := strings.Split(p, "/"); len(t) == 3 && t[0] == "accounts" && t[2] == "index.db" {
+			lp = filepath.Dir(lp)
+		}
+		if err := os.RemoveAll(lp); err != nil {
+			return stats, fmt.Errorf("removing %s: %v", p, err)
+		}
+		stats.Removed++
+		delete(st.Versions, p)
+		log.Debug("removed replicated file", slog.String("path", p))
+	}
+
+	st.LastManifest = m.Time
+	st.LastDigest = m.Digest()
+	st.LastSync = time.Now()
+	st.LastError = ""
+	st.LastErrorTime = time.Time{}
+	return stats, nil
+}
+
+// validPath returns whether p is a relative path without "..", so it stays
+// within the data directory.
+func validPath(p string) bool {
+	return p != "" && !path.IsAbs(p) && path.Clean(p) == p && !strings.HasPrefix(p, "../") && p != ".." && !strings.Contains(p, "\\")
+}
+
+// syncMessages fetches the message files referenced by the account or queue
+// database at newdbpath that are missing, and removes message files that are not
+// referenced. Message files of an account are also fetched if the format or
+// checksum of the message changed compared to the previous copy of the database
+// at dbpath, i.e. if the message file was rewritten on the primary. An empty
+// account is for the queue.
+func (s Standby) syncMessages(ctx context.Context, log mlog.Log, account, dbpath, newdbpath string, stats *SyncStats) error {
+	dir := filepath.Join(s.DataDir, "queue")
+	if account != "" {
+		dir = filepath.Join(s.DataDir, "accounts", account, "msg")
+	}
+
+	ids, err := messageVersions(ctx, log, account, newdbpath)
+	if err != nil {
+		return err
+	}
+	prevIDs, err := messageVersions(ctx, log, account, dbpath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("previous copy: %v", err)
+	}
+
+	// Remove files no longer referenced, and keep track of the files we have.
+	have := map[string]struct{}{}
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == dir && errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		mp := p[len(dir)+1:]
+		if account == "" && (mp == "index.db" || strings.HasPrefix(mp, "index.db.")) {
+			return nil
+		}
+		if mv, ok := ids[mp]; ok {
+			if pmv, ok := prevIDs[mp]; !ok || pmv.version == mv.version {
+				have[mp] = struct{}{}
+			}
+			return nil
+		}
+		if err := os.Remove(p); err != nil {
+			return err
+		}
+		stats.Removed++
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("removing message files: %v", err)
+	}
+
+	for mp, mv := range ids {
+		if _, ok := have[mp]; ok {
+			continue
+		}
+		id := mv.id
+		params := url.Values{"account": {account}, "id": {fmt.Sprintf("%d", id)}}
+		err := s.fetch(ctx, "message", params, filepath.Join(dir, mp), stats)
+		if errors.Is(err, fs.ErrNotExist) {
+			// Removed since the database was copied.
+			continue
+		} else if err != nil {
+			return fmt.Errorf("fetching message %d: %v", id, err)
+		}
+		stats.Messages++
+	}
+	return nil
+}
+
+// messageVersion is a message in a copy of an account or queue database. The
+// version changes when the message file is rewritten on the primary.
+type messageVersion struct {
+	id      int64
+	version string
+}
+
+// messageVersions returns the messages in the copy of the account or queue
+// database at dbpath, keyed by path of their message file.
+func messageVersions(ctx context.Context, log mlog.Log, account, dbpath string) (map[string]messageVersion, error) {
+	if _, err := os.Stat(dbpath); err != nil {
+		return nil, err
+	}
+	types := queue.DBTypes
+	if account != "" {
+		types = store.DBTypes
+	}
+	db, err := bstore.Open(ctx, dbpath, &bstore.Options{MustExist: true}, types...)
+	if err != nil {
+		return nil, fmt.Errorf("open database: %v", err)
+	}
+	defer func() {
+		err := db.Close()
+		log.Check(err, "closing replicated database")
+	}()
+
+	ids := map[string]messageVersion{}
+	if account == "" {
+		err = bstore.QueryDB[queue.Msg](ctx, db).ForEach(func(m queue.Msg) error {
+			ids[store.MessagePath(m.ID)] = messageVersion{m.ID, ""}
+			return nil
+		})
+	} else {
+		err = bstore.QueryDB[store.Message](ctx, db).FilterEqual("Expunged", false).ForEach(func(m store.Message) error {
+			v := fmt.Sprintf("%v-%v-%x", m.FileEncrypted, m.FileCompressed, m.Checksum)
+			ids[store.MessagePath(m.ID)] = messageVersion{m.ID, v}
+			return nil
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("listing messages: %v", err)
+	}
+	return ids, nil
+}
+
+// Run synchronizes with the primary until ctx is canceled. The primary holds
+// requests until something changed, so changes are replicated as they happen.
+// Synchronizations start at most once per interval, and are retried after an
+// interval when they fail.
+func (s Standby) Run(ctx context.Context, log mlog.Log, interval time.Duration) {
+	s.Wait = true
+	for {
+		t0 := time.Now()
+		stats, err := s.Sync(ctx, log)
+		if err != nil && ctx.Err() == nil {
+			log.Errorx("replication sync", err)
+		} else if err == nil {
+			log.Debug("replication sync",
+				slog.Int("files", stats.Files),
+				slog.Int("messages", stats.Messages),
+				slog.Int("removed", stats.Removed),
+				slog.Int64("bytes", stats.Bytes),
+				slog.Duration("duration", time.Since(t0)))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval - time.Since(t0)):
+		}
+	}
+}
@@ -0,0 +1,520 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/store"
+)
+
+func cmdRestore(c *cmd) {
+	c.params = "[-mailbox name] [-prefix mailbox] backup-dir account"
+	c.help = `Restore messages of an account from a backup into the running mox instance.
+
+The messages in all mailboxes of the account in the backup directory are added
+to the account, into mailboxes with the same name, which are created if needed.
+Other accounts are not affected, and mox does not have to be stopped. The
+account must exist in the configuration.
+
+With -mailbox, only messages of that mailbox and its child mailboxes are
+restored. With -prefix, e.g. "Restored", the mailboxes are restored under that
+mailbox, e.g. "Restored/Inbox", leaving the existing mailboxes as they are.
+
+Messages that are already present in the destination mailbox, with the same
+Message-ID, size and receive time, are skipped, so a restore can be repeated.
+Message flags and keywords are restored. Messages that were removed from a
+mailbox after the backup was made are added again, and messages added after the
+backup are kept.
+
+To restore to a point in time, use the backup made at that time. For backups
+streamed with "mox backup -", first create a backup directory with "mox
+extractbackup". The backup directory is accessed by the running mox process,
+so it must have access to it, e.g. by placing it in the "data/tmp/" directory.
+`
+	var mailbox, prefix string
+	c.flag.StringVar(&mailbox, "mailbox", "", "only restore messages of this mailbox and its children")
+	c.flag.StringVar(&prefix, "prefix", "", "restore mailboxes under this mailbox")
+	args := c.Parse()
+	if len(args) != 2 {
+		c.Usage()
+	}
+	mustLoadConfig()
+
+	backupDir, err := filepath.Abs(args[0])
+	xcheckf(err, "making path absolute")
+	ctlcmdRestore(xctl(), backupDir, args[1], mailbox, prefix)
+}
+
+func ctlcmdRestore(ctl *ctl, backupDir, account, mailbox, prefix string) {
+	ctl.xwrite("restore")
+	ctl.xwrite(backupDir)
+	ctl.xwrite(account)
+	if strings.EqualFold(mailbox, "Inbox") {
+		mailbox = "Inbox"
+	}
+	ctl.xwrite(mailbox)
+	ctl.xwrite(prefix)
+	ctl.xreadok()
+	fmt.Fprintln(os.Stderr, "restoring...")
+	for {
+		line := ctl.xread()
+		if strings.HasPrefix(line, "progress ") {
+			n := line[len("progress "):]
+			fmt.Fprintf(os.Stderr, "%s...\n", n)
+			continue
+		}
+		if line != "ok" {
+			log.Fatalf("restore, expected ok, got %q", line)
+		}
+		break
+	}
+	restored := ctl.xread()
+	skipped := ctl.xread()
+	fmt.Fprintf(os.Stderr, "%s restored, %s already present\n", restored, skipped)
+}
+
+func restorectl(ctx context.Context, ctl *ctl) {
+	/* protocol:
+	> "restore"
+	> backup directory
+	> account
+	> mailbox, or empty for all
+	> prefix, or empty
+	< "ok" or error
+	< "progress" count (zero or more times, once for every 1000 messages)
+	< "ok" when done, or error
+	< count of restored messages
+	< count of skipped messages, already present
+	*/
+	backupDir := ctl.xread()
+	account := ctl.xread()
+	mailbox := ctl.xread()
+	prefix := strings.Trim(ctl.xread(), "/")
+
+	ctl.log.Info("restoring messages from backup",
+		slog.String("backupdir", backupDir),
+		slog.String("account", account),
+		slog.String("mailbox", mailbox),
+		slog.String("prefix", prefix))
+
+	// Open the database from the backup. We don't modify the backup, but opening
+	// the database may upgrade its schema.
+	bdbpath := filepath.Join(backupDir, "accounts", account, "index.db")
+	bdb, err := bstore.Open(ctx, bdbpath, &bstore.Options{MustExist: true}, store.DBTypes...)
+	ctl.xcheck(err, "opening account database in backup")
+	defer func() {
+		err := bdb.Close()
+		ctl.log.Check(err, "closing account database of backup")
+	}()
+	err = store.UpgradeSealed(ctx, ctl.log, account, bdb)
+	ctl.xcheck(err, "upgrading messages in account database in backup")
+
+	bmailboxes, err := bstore.QueryDB[store.Mailbox](ctx, bdb).FilterFn(func(mb store.Mailbox) bool {
+		return mailbox == "" || mb.Name == mailbox || strings.HasPrefix(mb.Name, mailbox+"/")
+	}).SortAsc("Name").List()
+	ctl.xcheck(err, "listing mailboxes in backup")
+	if len(bmailboxes) == 0 {
+		ctl.xerror("no matching mailboxes in backup")
+	}
+
+	a, err := store.OpenAccount(ctl.log, account)
+	ctl.xcheck(err, "opening account")
+	defer func() {
+		if a != nil {
+			err := a.Close()
+			ctl.log.Check(err, "closing account after restore")
+		}
+	}()
+
+	err = a.ThreadingWait(ctl.log)
+	ctl.xcheck(err, "waiting for account thread upgrade")
+
+	tx, err := a.DB.Begin(ctx, true)
+	ctl.xcheck(err, "begin transaction")
+	defer func() {
+		if tx != nil {
+			err := tx.Rollback()
+			ctl.log.Check(err, "rolling back transaction")
+		}
+	}()
+
+	// All preparations done. Good to go.
+	ctl.xwriteok()
+
+	// We will be delivering messages. If we fail halfway, we need to remove the created msg files.
+	var deliveredIDs []int64
+
+	defer func() {
+		x := recover()
+		if x == nil {
+			return
+		}
+
+		if x != ctl.x {
+			ctl.log.Error("restore error", slog.String("panic", fmt.Sprintf("%v", x)))
+			debug.PrintStack()
+			metrics.PanicInc(metrics.Ctl)
+		} else {
+			ctl.log.Error("restore error")
+		}
+
+		for _, id := range deliveredIDs {
+			p := a.MessagePath(id)
+			err := os.Remove(p)
+			ctl.log.Check(err, "removing message file after restore error", slog.String("path", p))
+		}
+
+		ctl.xerror(fmt.Sprintf("restore error: %v", x))
+	}()
+
+	// Messages are identified by Message-ID, size and receive time, for skipping
+	// messages already present.
+	msgKey := func(m store.Message) string {
+		return fmt.Sprintf("%s %d %d", m.MessageID, m.Size, m.Received.UnixNano())
+	}
+
+	var changes []store.Change
+	var n, skipped int
+	a.WithWLock(func() {
+		var modseq store.ModSeq // Assigned on first delivered message, used for all messages.
+
+		maxSize := a.QuotaMessageSize()
+		var addSize int64
+		du := store.DiskUsage{ID: 1}
+		err = tx.Get(&du)
+		ctl.xcheck(err, "get disk usage")
+
+		key := store.MessageKey(account)
+
+		for _, bmb := range bmailboxes {
+			name := bmb.Name
+			if prefix != "" {
+				name = prefix + "/" + name
+			}
+			mb, nchanges, err := a.MailboxEnsure(tx, name, true)
+			ctl.xcheck(err, "ensuring mailbox exists")
+			changes = append(changes, nchanges...)
+
+			present := map[string]struct{}{}
+			err = bstore.QueryTx[store.Message](tx).FilterNonzero(store.Message{MailboxID: mb.ID}).FilterEqual("Expunged", false).ForEach(func(m store.Message) error {
+				present[msgKey(m)] = struct{}{}
+				return nil
+			})
+			ctl.xcheck(err, "listing messages in mailbox")
+
+			var keywords []string
+			bq := bstore.QueryDB[store.Message](ctx, bdb)
+			bq.FilterNonzero(store.Message{MailboxID: bmb.ID})
+			bq.FilterEqual("Expunged", false)
+			bq.SortAsc("UID")
+			err = bq.ForEach(func(bm store.Message) error {
+				if _, ok := present[msgKey(bm)]; ok {
+					skipped++
+					return nil
+				}
+
+				addSize += bm.Size
+				if maxSize > 0 && du.MessageSize+addSize > maxSize {
+					ctl.xcheck(fmt.Errorf("account over maximum total message size %d", maxSize), "checking quota")
+				}
+
+				// Copy the message file from the backup, it may be encrypted and/or compressed.
+				// The message file is written again by DeliverMessage, as configured.
+				bpath := filepath.Join(backupDir, "accounts", account, "msg", store.MessagePath(bm.ID))
+				msgFile, err := store.CreateMessageTemp(ctl.log, "restore")
+				ctl.xcheck(err, "creating temporary message file")
+				defer store.CloseRemoveTempFile(ctl.log, msgFile, "message to restore")
+				mr := store.PathMsgReader(nil, bpath, key, bm.FileEncrypted, bm.FileCompressed, bm.Size-int64(len(bm.Sealed.MsgPrefix)))
+				_, err = io.Copy(msgFile, mr)
+				mr.Close()
+				ctl.xcheck(err, "copying message file from backup")
+
+				if modseq == 0 {
+					modseq, err = a.NextModSeq(tx)
+					ctl.xcheck(err, "assigning next modseq")
+				}
+
+				// Keep the message and its parsed form, but not the database references.
+				m := bm
+				m.ID = 0
+				m.UID = 0
+				m.MailboxID = mb.ID
+				m.MailboxOrigID = mb.ID
+				m.MailboxDestinedID = 0
+				m.CreateSeq = modseq
+				m.ModSeq = modseq
+				m.ThreadID = 0
+				m.ThreadParentIDs = nil
+				m.ThreadMissingLink = false
+				m.TrainedJunk = nil
+				m.TrainedShared = false
+
+				const sync = false
+				const notrain = true
+				const nothreads = true
+				const updateDiskUsage = false
+				err = a.DeliverMessage(ctl.log, tx, &m, msgFile, sync, notrain, nothreads, updateDiskUsage)
+				ctl.xcheck(err, "delivering message")
+				deliveredIDs = append(deliveredIDs, m.ID)
+				changes = append(changes, m.ChangeAddUID())
+				mb.Add(m.MailboxCounts())
+				keywords = append(keywords, m.Keywords...)
+
+				n++
+				if n%1000 == 0 {
+					ctl.xwrite(fmt.Sprintf("progress %d", n))
+				}
+				return nil
+			})
+			ctl.xcheck(err, "restoring messages")
+
+			// Get mailbox again, uidnext is likely updated.
+			mc := mb.MailboxCounts
+			err = tx.Get(&mb)
+			ctl.xcheck(err, "get mailbox")
+			mb.MailboxCounts = mc
+
+			var mbKwChanged bool
+			mb.Keywords, mbKwChanged = store.MergeKeywords(mb.Keywords, keywords)
+			if mbKwChanged {
+				changes = append(changes, mb.ChangeKeywords())
+			}
+
+			err = tx.Update(&mb)
+			ctl.xcheck(err, "updating message counts and keywords in mailbox")
+			changes = append(changes, mb.ChangeCounts())
+		}
+
+		// Match threads.
+		if len(deliveredIDs) > 0 {
+			err = a.AssignThreads(ctx, ctl.log, tx, deliveredIDs[0], 0, io.Discard)
+			ctl.xcheck(err, "assigning messages to threads")
+		}
+
+		err = a.AddMessageSize(ctl.log, tx, addSize)
+		ctl.xcheck(err, "updating total message size")
+
+		err = tx.Commit()
+		ctl.xcheck(err, "commit")
+		tx = nil
+		ctl.log.Info("restored messages from backup", slog.Int("count", len(deliveredIDs)), slog.Int("skipped", skipped))
+		deliveredIDs = nil
+
+		store.BroadcastChanges(a, changes)
+	})
+
+	err = a.Close()
+	ctl.xcheck(err, "closing account")
+	a = nil
+
+	ctl.xwriteok()
+	ctl.xwrite(fmt.Sprintf("%d", n))
+	ctl.xwrite(fmt.Sprintf("%d", skipped))
+}
+
+func cmdExtractbackup(c *cmd) {
+	c.params = "dest-dir backup.tar [incremental.tar ...]"
+	c.help = `Create a backup directory from streamed backups.
+
+Backups written with "mox backup -" are tar files. An incremental streamed
+backup only contains the changes since the previous backup. Extractbackup
+creates a backup directory from a full backup and zero or more incremental
+backups, applied in order. The result is the backup at the time of the last
+incremental backup given, i.e. a point-in-time restore. Each incremental backup
+must have been made against the previous backup. A tar file can be "-" for
+stdin.
+
+The resulting directory is a regular backup directory, it can be checked with
+"mox verifybackup" and "mox verifydata", and used with "mox restore" or as new
+data directory.
+`
+	args := c.Parse()
+	if len(args) < 2 {
+		c.Usage()
+	}
+
+	dstDir := args[0]
+	if _, err := os.Stat(dstDir); err == nil {
+		log.Fatalf("destination directory already exists")
+	}
+	err := os.MkdirAll(dstDir, 0770)
+	xcheckf(err, "creating destination directory")
+
+	var prev *backupManifest
+	for _, p := range args[1:] {
+		var r io.Reader = os.Stdin
+		if p != "-" {
+			f, err := os.Open(p)
+			xcheckf(err, "open backup")
+			r = f
+			defer f.Close()
+		}
+		m, err := extractBackup(dstDir, r, prev)
+		xcheckf(err, "extracting %s", p)
+		prev = &m
+	}
+
+	// Remove files that are not in the last backup, e.g. messages that were removed,
+	// and write the manifest for a full backup.
+	known := map[string]struct{}{backupManifestName: {}}
+	for i := range prev.Files {
+		e := &prev.Files[i]
+		known[e.Path] = struct{}{}
+		e.Omitted = false
+		e.Delta = false
+		e.DeltaChunks = nil
+	}
+	err = filepath.WalkDir(dstDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if _, ok := known[filepath.ToSlash(p[len(filepath.Clean(dstDir))+1:])]; !ok {
+			return os.Remove(p)
+		}
+		return nil
+	})
+	xcheckf(err, "removing files not in last backup")
+	prev.Previous = time.Time{}
+	buf, err := json.MarshalIndent(prev, "", "\t")
+	xcheckf(err, "marshal manifest")
+	err = os.WriteFile(filepath.Join(dstDir, backupManifestName), buf, 0660)
+	xcheckf(err, "writing manifest")
+	fmt.Printf("backup of %s extracted\n", prev.Time.Format(time.RFC3339))
+}
+
+// extractBackup extracts a streamed backup into dstDir, applying database deltas
+// to files from the previous backup.
+func extractBackup(dstDir string, r io.Reader, prev *backupManifest) (backupManifest, error) {
+	var m backupManifest
+	var deltas []string
+
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return m, fmt.Errorf("reading tar file: %v", err)
+		}
+		if h.Typeflag != tar.TypeReg || !filepath.IsLocal(filepath.FromSlash(h.Name)) {
+			return m, fmt.Errorf("unexpected file %q in backup", h.Name)
+		}
+		if h.Name == backupManifestName {
+			if err := json.NewDecoder(tr).Decode(&m); err != nil {
+				return m, fmt.Errorf("parsing manifest: %v", err)
+			}
+			continue
+		}
+		if strings.HasSuffix(h.Name, ".delta") {
+			deltas = append(deltas, h.Name)
+		}
+		p := filepath.Join(dstDir, filepath.FromSlash(h.Name))
+		if err := os.MkdirAll(filepath.Dir(p), 0770); err != nil {
+			return m, err
+		}
+		f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0660)
+		if err != nil {
+			return m, err
+		}
+		_, err = io.Copy(f, tr)
+		if xerr := f.Close(); err == nil {
+			err = xerr
+		}
+		if err != nil {
+			return m, fmt.Errorf("writing %s: %v", h.Name, err)
+		}
+	}
+	if m.Version != 1 {
+		return m, fmt.Errorf("missing manifest or unsupported manifest version %d", m.Version)
+	}
+	if prev == nil && !m.Previous.IsZero() {
+		return m, fmt.Errorf("first backup is incremental, a full backup is needed")
+	} else if prev != nil && !m.Previous.Equal(prev.Time) {
+		return m, fmt.Errorf("incremental backup is against backup of %s, not of previous backup at %s", m.Previous.Format(time.RFC3339), prev.Time.Format(time.RFC3339))
+	}
+
+	for _, e := range m.Files {
+		p := filepath.Join(dstDir, filepath.FromSlash(e.Path))
+		if e.Delta {
+			if err := applyBackupDelta(p, e); err != nil {
+				return m, fmt.Errorf("applying delta for %s: %v", e.Path, err)
+			}
+			deltas = slices.DeleteFunc(deltas, func(s string) bool { return s == e.Path+".delta" })
+		} else if e.Omitted {
+			if fi, err := os.Stat(p); err != nil {
+				return m, fmt.Errorf("file %s from previous backup: %v", e.Path, err)
+			} else if fi.Size() != e.Size {
+				return m, fmt.Errorf("file %s from previous backup has size %d, expected %d", e.Path, fi.Size(), e.Size)
+			}
+		}
+	}
+	if len(deltas) > 0 {
+		return m, fmt.Errorf("delta files not in manifest: %s", strings.Join(deltas, ", "))
+	}
+	return m, nil
+}
+
+// applyBackupDelta updates the database file at p with the chunks from the delta
+// file next to it, and removes the delta file.
+func applyBackupDelta(p string, e backupEntry) (rerr error) {
+	old, err := os.Open(p)
+	if err != nil {
+		return fmt.Errorf("database from previous backup: %v", err)
+	}
+	defer old.Close()
+	delta, err := os.Open(p + ".delta")
+	if err != nil {
+		return err
+	}
+	defer delta.Close()
+
+	tmp := p + ".new"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0660)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+		if rerr != nil {
+			os.Remove(tmp)
+		}
+	}()
+
+	for i := range e.Chunks {
+		off := int64(i) * backupChunkSize
+		n := min(backupChunkSize, e.Size-off)
+		var src io.Reader = io.NewSectionReader(old, off, n)
+		if slices.Contains(e.DeltaChunks, i) {
+			src = delta
+		}
+		if _, err := io.CopyN(f, src, n); err != nil {
+			return fmt.Errorf("copying chunk %d: %v", i, err)
+		}
+	}
+	err = f.Close()
+	f = nil
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, p); err != nil {
+		return err
+	}
+	return os.Remove(p + ".delta")
+}
@@ -0,0 +1,238 @@
+// Package retention applies the retention rules of accounts, moving old messages
+// to archive mailboxes and removing old messages, e.g. from Trash and Junk.
+//
+// Rules are managed by users in webmail, and applied periodically in the
+// background. Rules in dry-run mode only record the number of matching messages.
+package retention
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/store"
+	"github.com/mjl-/mox/webops"
+)
+
+// Interval between runs over all accounts.
+const interval = 6 * time.Hour
+
+// Number of messages moved or removed in a single transaction.
+const batchSize = 1000
+
+// Result is the outcome of evaluating a rule.
+type Result struct {
+	Matched int   // Number of messages matched.
+	Size    int64 // Total size of matched messages.
+}
+
+// opError is raised as panic by the shared message operations and returned as
+// error by Apply.
+type opError struct {
+	err error
+}
+
+func xcheckf(ctx context.Context, err error, format string, args ...any) {
+	if err != nil {
+		panic(opError{fmt.Errorf("%s: %w", fmt.Sprintf(format, args...), err)})
+	}
+}
+
+var xops = webops.XOps{
+	DBWrite: func(ctx context.Context, acc *store.Account, fn func(tx *bstore.Tx)) {
+		err := acc.DB.Write(ctx, func(tx *bstore.Tx) error {
+			fn(tx)
+			return nil
+		})
+		xcheckf(ctx, err, "transaction")
+	},
+	Checkf:     xcheckf,
+	Checkuserf: xcheckf,
+}
+
+// Apply evaluates rule against the messages in its mailbox, and unless dryRun is
+// set, moves or removes the matching messages. Messages are matched based on
+// their age at now. The rule is applied even if it is disabled. A rule for a
+// mailbox that does not exist matches no messages.
+func Apply(ctx context.Context, log mlog.Log, acc *store.Account, rule store.RetentionRule, now time.Time, dryRun bool) (result Result, rerr error) {
+	defer func() {
+		x := recover()
+		if x == nil {
+			return
+		}
+		if err, ok := x.(opError); ok {
+			rerr = err.err
+			return
+		}
+		panic(x)
+	}()
+
+	cutoff := rule.Cutoff(now)
+
+	// Gather matching messages, per destination mailbox.
+	destIDs := map[string][]int64{}
+	var dests []string
+	err := acc.DB.Read(ctx, func(tx *bstore.Tx) error {
+		mb, err := acc.MailboxFind(tx, rule.Mailbox)
+		if err != nil {
+			return fmt.Errorf("looking up mailbox: %v", err)
+		} else if mb == nil {
+			return nil
+		}
+
+		q := bstore.QueryTx[store.Message](tx)
+		q.FilterNonzero(store.Message{MailboxID: mb.ID})
+		q.FilterEqual("Expunged", false)
+		q.FilterLess("Received", cutoff)
+		q.SortAsc("Received")
+		return q.ForEach(func(m store.Message) error {
+			var env *message.Envelope
+			if rule.NeedsEnvelope() {
+				msgr := acc.MessageReader(m)
+				p, err := m.LoadPart(msgr)
+				err2 := msgr.Close()
+				log.Check(err2, "closing message reader")
+				if err != nil {
+					log.Debugx("loading message part for retention rule", err, slog.Int64("msgid", m.ID))
+					return nil
+				}
+				env = p.Envelope
+			}
+			if !rule.Match(m, env, cutoff) {
+				return nil
+			}
+			result.Matched++
+			result.Size += m.Size
+			dest := rule.DestinationMailbox(m)
+			if _, ok := destIDs[dest]; !ok {
+				dests = append(dests, dest)
+			}
+			destIDs[dest] = append(destIDs[dest], m.ID)
+			return nil
+		})
+	})
+	if err != nil {
+		return Result{}, fmt.Errorf("evaluating rule: %w", err)
+	}
+	if dryRun || result.Matched == 0 {
+		return result, nil
+	}
+
+	for _, dest := range dests {
+		ids := destIDs[dest]
+
+		var mbDst store.Mailbox
+		if rule.Action != store.RetentionDelete {
+			acc.WithWLock(func() {
+				var changes []store.Change
+				xops.DBWrite(ctx, acc, func(tx *bstore.Tx) {
+					var err error
+					mbDst, changes, err = acc.MailboxEnsure(tx, dest, true)
+					xcheckf(ctx, err, "ensuring destination mailbox")
+				})
+				store.BroadcastChanges(acc, changes)
+			})
+		}
+
+		for len(ids) > 0 {
+			n := min(len(ids), batchSize)
+			if rule.Action == store.RetentionDelete {
+				xops.MessageDelete(ctx, log, acc, ids[:n])
+			} else {
+				xops.MessageMove(ctx, log, acc, ids[:n], "", mbDst.ID)
+			}
+			ids = ids[n:]
+		}
+	}
+	log.Info("retention rule applied",
+		slog.String("account", acc.Name),
+		slog.String("mailbox", rule.Mailbox),
+		slog.Any("action", rule.Action),
+		slog.Int("messages", result.Matched))
+	return result, nil
+}
+
+// RunAccount applies all enabled rules of the account, and records the results
+// in the rules.
+func RunAccount(ctx context.Context, log mlog.Log, acc *store.Account, now time.Time) error {
+	rules, err := acc.RetentionRules(ctx)
+	if err != nil {
+		return fmt.Errorf("listing retention rules: %v", err)
+	}
+	for _, r := range rules {
+		if r.Disabled {
+			continue
+		}
+		result, err := Apply(ctx, log, acc, r, now, r.DryRun)
+		if err != nil {
+			log.Errorx("applying retention rule", err, slog.String("account", acc.Name), slog.Int64("rule", r.ID))
+		}
+		if err := acc.RetentionRuleResult(ctx, r.ID, result.Matched, err); err != nil {
+			return fmt.Errorf("storing result of retention rule: %v", err)
+		}
+	}
+	return nil
+}
+
+// runAll runs the rules of all accounts.
+func runAll(ctx context.Context, log mlog.Log) {
+	for _, accName := range mox.Conf.Accounts() {
+		if ctx.Err() != nil {
+			return
+		}
+		runAccountSafe(ctx, log, accName)
+	}
+}
+
+func runAccountSafe(ctx context.Context, log mlog.Log, accName string) {
+	defer func() {
+		x := recover()
+		if x != nil {
+			log.Error("recover from panic", slog.Any("panic", x), slog.String("account", accName))
+			debug.PrintStack()
+			metrics.PanicInc(metrics.Retention)
+		}
+	}()
+
+	acc, err := store.OpenAccount(log, accName)
+	if err != nil {
+		log.Errorx("open account for retention rules", err, slog.String("account", accName))
+		return
+	}
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	err = RunAccount(ctx, log, acc, time.Now())
+	log.Check(err, "running retention rules", slog.String("account", accName))
+}
+
+// Start periodically applies the retention rules of all accounts, starting a few
+// minutes after startup.
+func Start() {
+	log := mlog.New("retention", nil)
+
+	go func() {
+		timer := time.NewTimer(5 * time.Minute)
+		defer timer.Stop()
+		for {
+			select {
+			case <-mox.Shutdown.Done():
+				return
+			case <-timer.C:
+			}
+
+			runAll(mox.Shutdown, log.WithCid(mox.Cid()))
+			timer.Reset(interval)
+		}
+	}()
+}
@@ -0,0 +1,147 @@
+package retention
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/store"
+)
+
+var ctxbg = context.Background()
+
+func tcheck(t *testing.T, err error, msg string) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %s", msg, err)
+	}
+}
+
+func tcompare(t *testing.T, got, exp any) {
+	t.Helper()
+	if got != exp {
+		t.Fatalf("got %v, expected %v", got, exp)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	log := mlog.New("retention", nil)
+	os.RemoveAll("../testdata/retention/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/retention/mox.conf")
+	mox.MustLoadConfig(true, false)
+	defer store.Switchboard()()
+	acc, err := store.OpenAccount(log, "mjl")
+	tcheck(t, err, "open account")
+	defer func() {
+		err := acc.Close()
+		tcheck(t, err, "closing account")
+		acc.CheckClosed()
+	}()
+
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	deliver := func(mailbox string, received time.Time, subject string, flags store.Flags) {
+		t.Helper()
+		msgFile, err := store.CreateMessageTemp(log, "retention-test")
+		tcheck(t, err, "create temp message")
+		defer os.Remove(msgFile.Name())
+		defer msgFile.Close()
+		msg := fmt.Sprintf("From: <remote@example.org>\r\nSubject: %s\r\n\r\nbody\r\n", subject)
+		_, err = msgFile.Write([]byte(msg))
+		tcheck(t, err, "write message")
+		m := store.Message{Received: received, Size: int64(len(msg)), Flags: flags}
+		acc.WithWLock(func() {
+			err = acc.DeliverMailbox(log, mailbox, &m, msgFile)
+		})
+		tcheck(t, err, "deliver message")
+	}
+	count := func(mailbox string) int {
+		t.Helper()
+		var n int
+		err := acc.DB.Read(ctxbg, func(tx *bstore.Tx) error {
+			mb, err := acc.MailboxFind(tx, mailbox)
+			if err != nil || mb == nil {
+				return err
+			}
+			n, err = bstore.QueryTx[store.Message](tx).FilterNonzero(store.Message{MailboxID: mb.ID}).FilterEqual("Expunged", false).Count()
+			return err
+		})
+		tcheck(t, err, "count messages")
+		return n
+	}
+
+	deliver("Inbox", now.AddDate(-2, 0, 0), "old", store.Flags{Seen: true})
+	deliver("Inbox", now.AddDate(-1, -1, 0), "older", store.Flags{Seen: true})
+	deliver("Inbox", now.AddDate(-2, 0, 0), "old flagged", store.Flags{Seen: true, Flagged: true})
+	deliver("Inbox", now.AddDate(-2, 0, 0), "old unread", store.Flags{})
+	deliver("Inbox", now.AddDate(0, 0, -1), "new", store.Flags{Seen: true})
+	deliver("Trash", now.AddDate(0, 0, -40), "trashed", store.Flags{Seen: true})
+	deliver("Trash", now.AddDate(0, 0, -2), "trashed recently", store.Flags{Seen: true})
+
+	archive, err := acc.RetentionRuleSave(ctxbg, store.RetentionRule{Mailbox: "Inbox", AgeDays: 365, Action: store.RetentionArchiveYear, Destination: "Archive", KeepFlagged: true, KeepUnread: true})
+	tcheck(t, err, "save rule")
+	purge, err := acc.RetentionRuleSave(ctxbg, store.RetentionRule{Mailbox: "Trash", AgeDays: 30, Action: store.RetentionDelete, DryRun: true})
+	tcheck(t, err, "save rule")
+
+	// Preview does not change anything.
+	result, err := Apply(ctxbg, log, acc, archive, now, true)
+	tcheck(t, err, "preview rule")
+	tcompare(t, result.Matched, 2)
+	tcompare(t, count("Inbox"), 5)
+
+	// Rule with condition on subject.
+	result, err = Apply(ctxbg, log, acc, store.RetentionRule{Mailbox: "Inbox", AgeDays: 1, Action: store.RetentionDelete, Subject: "FLAGGED"}, now, true)
+	tcheck(t, err, "preview rule")
+	tcompare(t, result.Matched, 1)
+
+	// Rule for mailbox that does not exist.
+	result, err = Apply(ctxbg, log, acc, store.RetentionRule{Mailbox: "Absent", AgeDays: 1, Action: store.RetentionDelete}, now, false)
+	tcheck(t, err, "apply rule")
+	tcompare(t, result.Matched, 0)
+
+	err = RunAccount(ctxbg, log, acc, now)
+	tcheck(t, err, "run rules")
+	tcompare(t, count("Inbox"), 3)
+	tcompare(t, count("Archive/2023"), 1)
+	tcompare(t, count("Archive/2024"), 1)
+	tcompare(t, count("Trash"), 2) // Dry run.
+
+	rules, err := acc.RetentionRules(ctxbg)
+	tcheck(t, err, "list rules")
+	tcompare(t, len(rules), 2)
+	tcompare(t, rules[0].ID, archive.ID)
+	tcompare(t, rules[0].LastMatched, 2)
+	tcompare(t, rules[1].LastMatched, 1)
+	tcompare(t, rules[1].LastRun.IsZero(), false)
+
+	// Without dry run, the old message is removed from Trash. Last results are kept.
+	purge.DryRun = false
+	purge, err = acc.RetentionRuleSave(ctxbg, purge)
+	tcheck(t, err, "save rule")
+	tcompare(t, purge.LastMatched, 1)
+	err = RunAccount(ctxbg, log, acc, now)
+	tcheck(t, err, "run rules")
+	tcompare(t, count("Trash"), 1)
+	tcompare(t, count("Inbox"), 3)
+
+	// Disabled rules are skipped.
+	purge.Disabled = true
+	purge.AgeDays = 1
+	_, err = acc.RetentionRuleSave(ctxbg, purge)
+	tcheck(t, err, "save rule")
+	err = RunAccount(ctxbg, log, acc, now)
+	tcheck(t, err, "run rules")
+	tcompare(t, count("Trash"), 1)
+
+	err = acc.RetentionRuleRemove(ctxbg, purge.ID)
+	tcheck(t, err, "remove rule")
+	rules, err = acc.RetentionRules(ctxbg)
+	tcheck(t, err, "list rules")
+	tcompare(t, len(rules), 1)
+}
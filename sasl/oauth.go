@@ -0,0 +1,156 @@
+package sasl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type clientOAuthBearer struct {
+	Username, Token string
+	step            int
+}
+
+var _ Client = (*clientOAuthBearer)(nil)
+
+// NewClientOAuthBearer returns a client for SASL OAUTHBEARER authentication with
+// an OAuth 2.0 bearer token.
+//
+// OAUTHBEARER is specified in RFC 7628, A Set of Simple Authentication and
+// Security Layer (SASL) Mechanisms for OAuth.
+func NewClientOAuthBearer(username, token string) Client {
+	return &clientOAuthBearer{username, token, 0}
+}
+
+func (a *clientOAuthBearer) Info() (name string, hasCleartextCredentials bool) {
+	return "OAUTHBEARER", true
+}
+
+func (a *clientOAuthBearer) Next(fromServer []byte) (toServer []byte, last bool, rerr error) {
+	defer func() { a.step++ }()
+	switch a.step {
+	case 0:
+		// RFC 7628 section 3.1, with gs2-header from RFC 5801 section 4.
+		authzid := strings.NewReplacer("=", "=3D", ",", "=2C").Replace(a.Username)
+		return []byte(fmt.Sprintf("n,a=%s,\x01auth=Bearer %s\x01\x01", authzid, a.Token)), true, nil
+	default:
+		return nil, false, fmt.Errorf("invalid step %d", a.step)
+	}
+}
+
+type clientXOAuth2 struct {
+	Username, Token string
+	step            int
+}
+
+var _ Client = (*clientXOAuth2)(nil)
+
+// NewClientXOAuth2 returns a client for the non-standard SASL XOAUTH2
+// authentication with an OAuth 2.0 bearer token, a predecessor of OAUTHBEARER
+// that is still commonly used by email clients.
+//
+// See https://developers.google.com/gmail/imap/xoauth2-protocol
+func NewClientXOAuth2(username, token string) Client {
+	return &clientXOAuth2{username, token, 0}
+}
+
+func (a *clientXOAuth2) Info() (name string, hasCleartextCredentials bool) {
+	return "XOAUTH2", true
+}
+
+func (a *clientXOAuth2) Next(fromServer []byte) (toServer []byte, last bool, rerr error) {
+	defer func() { a.step++ }()
+	switch a.step {
+	case 0:
+		return []byte(fmt.Sprintf("user=%s\x01auth=Bearer %s\x01\x01", a.Username, a.Token)), true, nil
+	default:
+		return nil, false, fmt.Errorf("invalid step %d", a.step)
+	}
+}
+
+// ErrOAuthSyntax is returned by ParseOAuthBearer and ParseXOAuth2 for malformed
+// client responses.
+var ErrOAuthSyntax = errors.New("malformed oauth client response")
+
+// ParseOAuthBearer parses the initial client response for OAUTHBEARER, RFC 7628
+// section 3.1, returning the authorization identity (typically the email
+// address, may be empty) and the bearer token.
+func ParseOAuthBearer(buf []byte) (authzid, token string, rerr error) {
+	s := string(buf)
+
+	// gs2-header, RFC 5801 section 4. Channel binding is not supported by OAUTHBEARER.
+	t := strings.SplitN(s, ",", 3)
+	if len(t) != 3 || (t[0] != "n" && t[0] != "y") {
+		return "", "", fmt.Errorf("%w: bad gs2 header", ErrOAuthSyntax)
+	}
+	if t[1] != "" {
+		if !strings.HasPrefix(t[1], "a=") {
+			return "", "", fmt.Errorf("%w: bad authzid", ErrOAuthSyntax)
+		}
+		authzid = strings.NewReplacer("=2C", ",", "=3D", "=").Replace(t[1][2:])
+	}
+	kvs := t[2]
+	if !strings.HasPrefix(kvs, "\x01") {
+		return "", "", fmt.Errorf("%w: missing separator after gs2 header", ErrOAuthSyntax)
+	}
+	token, err := oauthToken(kvs[1:], nil)
+	return authzid, token, err
+}
+
+// ParseXOAuth2 parses the initial client response for XOAUTH2, returning the
+// user (the email address) and the bearer token.
+func ParseXOAuth2(buf []byte) (user, token string, rerr error) {
+	token, err := oauthToken(string(buf), &user)
+	return user, token, err
+}
+
+// oauthToken parses key/value pairs separated by ctrl-a, ending with two
+// ctrl-a's, returning the token from the "auth" key. If user is not nil, it is
+// set to the value of the "user" key.
+func oauthToken(s string, user *string) (string, error) {
+	if !strings.HasSuffix(s, "\x01\x01") {
+		return "", fmt.Errorf("%w: missing final separators", ErrOAuthSyntax)
+	}
+	var token string
+	for _, kv := range strings.Split(strings.TrimSuffix(s, "\x01\x01"), "\x01") {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return "", fmt.Errorf("%w: bad key/value pair", ErrOAuthSyntax)
+		}
+		switch k {
+		case "auth":
+			// RFC 6750 section 2.1, scheme is case-insensitive.
+			scheme, tok, ok := strings.Cut(v, " ")
+			if !ok || !strings.EqualFold(scheme, "bearer") || tok == "" {
+				return "", fmt.Errorf("%w: auth value must be a bearer token", ErrOAuthSyntax)
+			}
+			token = tok
+		case "user":
+			if user != nil {
+				*user = v
+			}
+		}
+	}
+	if token == "" {
+		return "", fmt.Errorf("%w: missing auth value", ErrOAuthSyntax)
+	}
+	if user != nil && *user == "" {
+		return "", fmt.Errorf("%w: missing user", ErrOAuthSyntax)
+	}
+	return token, nil
+}
+
+// OAuthErrorChallenge returns the server challenge with error details for a
+// failed OAUTHBEARER or XOAUTH2 authentication, RFC 7628 section 3.2.2. The
+// client must respond before the server fails the authentication. If
+// configurationURL is not empty, it points clients to the identity provider.
+func OAuthErrorChallenge(configurationURL string) []byte {
+	resp := struct {
+		Status              string `json:"status"`
+		Scope               string `json:"scope"`
+		OpenIDConfiguration string `json:"openid-configuration,omitempty"`
+	}{"invalid_token", "openid email", configurationURL}
+	buf, _ := json.Marshal(resp)
+	return buf
+}
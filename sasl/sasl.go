@@ -5,6 +5,7 @@ import (
 	"crypto/md5"
 	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/sha512"
 	"crypto/tls"
 	"fmt"
 	"hash"
@@ -258,6 +259,32 @@ func NewClientSCRAMSHA256PLUS(username, password string, cs tls.ConnectionState)
 	return &clientSCRAMSHA{username, password, sha256.New, true, cs, false, "SCRAM-SHA-256-PLUS", 0, nil}
 }
 
+// NewClientSCRAMSHA512 returns a client for SASL SCRAM-SHA-512 authentication.
+//
+// Clients should prefer using the PLUS-variant with TLS channel binding, if
+// supported by a server. If noServerPlus is set, this mechanism was chosen because
+// the PLUS-variant was not supported by the server. If the server actually does
+// implement the PLUS variant, this can indicate a MitM attempt, which is detected
+// by the server and causes the authentication attempt to be aborted.
+//
+// SCRAM-SHA-512 is specified in draft-ietf-kitten-scram-sha-512, following the
+// pattern of RFC 7677.
+func NewClientSCRAMSHA512(username, password string, noServerPlus bool) Client {
+	password = precisPassword(password)
+	return &clientSCRAMSHA{username, password, sha512.New, false, tls.ConnectionState{}, noServerPlus, "SCRAM-SHA-512", 0, nil}
+}
+
+// NewClientSCRAMSHA512PLUS returns a client for SASL SCRAM-SHA-512-PLUS authentication.
+//
+// The PLUS-variant binds the authentication exchange to the TLS connection,
+// detecting any MitM attempt.
+//
+// SCRAM-SHA-512-PLUS is specified in draft-ietf-kitten-scram-sha-512.
+func NewClientSCRAMSHA512PLUS(username, password string, cs tls.ConnectionState) Client {
+	password = precisPassword(password)
+	return &clientSCRAMSHA{username, password, sha512.New, true, cs, false, "SCRAM-SHA-512-PLUS", 0, nil}
+}
+
 func (a *clientSCRAMSHA) Info() (name string, hasCleartextCredentials bool) {
 	return a.name, false
 }
@@ -1,6 +1,6 @@
 // Package scram implements the SCRAM-SHA-* SASL authentication mechanism, RFC 7677 and RFC 5802.
 //
-// SCRAM-SHA-256 and SCRAM-SHA-1 allow a client to authenticate to a server using a
+// SCRAM-SHA-512, SCRAM-SHA-256 and SCRAM-SHA-1 allow a client to authenticate to a server using a
 // password without handing plaintext password over to the server. The client also
 // verifies the server knows (a derivative of) the password. Both the client and
 // server side are implemented.
@@ -134,7 +134,7 @@ type Server struct {
 	Authentication string // Username for authentication, "authc". Always set and non-empty.
 	Authorization  string // If set, role of user to assume after authentication, "authz".
 
-	h func() hash.Hash // sha1.New or sha256.New
+	h func() hash.Hash // sha1.New, sha256.New or sha512.New
 
 	// Messages used in hash calculations.
 	clientFirstBare         string
@@ -329,7 +329,7 @@ type Client struct {
 	authc string
 	authz string
 
-	h            func() hash.Hash     // sha1.New or sha256.New
+	h            func() hash.Hash     // sha1.New, sha256.New or sha512.New
 	noServerPlus bool                 // Server did not announce support for PLUS-variant.
 	cs           *tls.ConnectionState // If set, use PLUS-variant.
 
@@ -347,7 +347,7 @@ type Client struct {
 }
 
 // NewClient returns a client for authentication authc, optionally for
-// authorization with role authz, for the hash (sha1.New or sha256.New).
+// authorization with role authz, for the hash (sha1.New, sha256.New or sha512.New).
 //
 // If noServerPlus is true, the client would like to have used the PLUS-variant,
 // that binds the authentication attempt to the TLS connection, but the client did
@@ -0,0 +1,251 @@
+// Package secondarymx accepts messages as secondary (backup) MX for domains of
+// which the accounts are on another mail server, the primary.
+//
+// Recipient addresses are checked against address lists, files with an email
+// address per line, that are read again when modified. Address lists can be
+// fetched periodically from a URL, e.g. a file on a web server on the primary
+// generated with "mox config addresses". Accepted messages are queued for
+// delivery to the primary through a transport, by the SMTP server.
+package secondarymx
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/moxio"
+	"github.com/mjl-/mox/moxvar"
+	"github.com/mjl-/mox/smtp"
+)
+
+var metricSync = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "mox_secondarymx_sync_total",
+		Help: "Fetches of address lists for secondary MX domains, by result.",
+	},
+	[]string{
+		"result", // ok, error
+	},
+)
+
+// DefaultSyncInterval is the interval between fetching address lists, if no other
+// interval is configured.
+const DefaultSyncInterval = time.Hour
+
+// maxListSize is the maximum size of an address list fetched from a URL.
+const maxListSize = 64 * 1024 * 1024
+
+// Domain returns the secondary MX configuration for domain d, if any.
+func Domain(d dns.Domain) (config.SecondaryMXDomain, bool) {
+	smx := mox.Conf.Static.SecondaryMX
+	if smx == nil {
+		return config.SecondaryMXDomain{}, false
+	}
+	for _, sd := range smx.Domains {
+		if sd.Domain == d {
+			return sd, true
+		}
+	}
+	return config.SecondaryMXDomain{}, false
+}
+
+// Accepts returns whether messages for addr are accepted, based on the address
+// list of the domain. Without address list, all addresses are accepted. If the
+// address list cannot be read, an error is returned.
+func Accepts(sd config.SecondaryMXDomain, addr smtp.Address) (bool, error) {
+	if sd.AddressesFile == "" {
+		return true, nil
+	}
+	l, err := addressList(sd.AddressesFile)
+	if err != nil {
+		return false, err
+	}
+	if _, ok := l["@"+addr.Domain.Name()]; ok {
+		return true, nil
+	}
+	lp := string(addr.Localpart)
+	for _, sep := range sd.LocalpartCatchallSeparators {
+		lp, _, _ = strings.Cut(lp, sep)
+	}
+	_, ok := l[strings.ToLower(lp)+"@"+addr.Domain.Name()]
+	return ok, nil
+}
+
+var addressLists = struct {
+	sync.Mutex
+	files map[string]addressListFile
+}{files: map[string]addressListFile{}}
+
+type addressListFile struct {
+	modTime   time.Time
+	size      int64
+	addresses map[string]struct{}
+}
+
+// addressList returns the addresses in file, reading it again if it was modified.
+// Addresses are normalized to a lower case localpart and a unicode domain.
+func addressList(file string) (map[string]struct{}, error) {
+	fi, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+
+	addressLists.Lock()
+	defer addressLists.Unlock()
+	if af, ok := addressLists.files[file]; ok && af.modTime.Equal(fi.ModTime()) && af.size == fi.Size() {
+		return af.addresses, nil
+	}
+
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	addresses := map[string]struct{}{}
+	for _, line := range strings.Split(string(buf), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "@") {
+			d, err := dns.ParseDomain(line[1:])
+			if err == nil {
+				addresses["@"+d.Name()] = struct{}{}
+			}
+			continue
+		}
+		addr, err := smtp.ParseAddress(line)
+		if err != nil {
+			continue
+		}
+		addresses[strings.ToLower(string(addr.Localpart))+"@"+addr.Domain.Name()] = struct{}{}
+	}
+	addressLists.files[file] = addressListFile{fi.ModTime(), fi.Size(), addresses}
+	return addresses, nil
+}
+
+// Start periodically fetches the address lists of domains with an AddressesURL.
+func Start() {
+	smx := mox.Conf.Static.SecondaryMX
+	if smx == nil {
+		return
+	}
+	log := mlog.New("secondarymx", nil)
+	interval := smx.SyncInterval
+	if interval == 0 {
+		interval = DefaultSyncInterval
+	}
+
+	go func() {
+		timer := time.NewTimer(0)
+		defer timer.Stop()
+		for {
+			select {
+			case <-mox.Shutdown.Done():
+				return
+			case <-timer.C:
+			}
+
+			syncAll(log.WithCid(mox.Cid()))
+			timer.Reset(interval)
+		}
+	}()
+}
+
+func syncAll(log mlog.Log) {
+	defer func() {
+		x := recover()
+		if x != nil {
+			log.Error("recover from panic", slog.Any("panic", x))
+			debug.PrintStack()
+			metrics.PanicInc(metrics.Secondarymx)
+		}
+	}()
+
+	for name, sd := range mox.Conf.Static.SecondaryMX.Domains {
+		if sd.AddressesURL == "" {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(mox.Shutdown, time.Minute)
+		n, err := Sync(ctx, log, sd)
+		cancel()
+		if err != nil {
+			metricSync.WithLabelValues("error").Inc()
+			log.Errorx("fetching address list for secondary mx domain, keeping previous list", err, slog.String("domain", name), slog.String("url", sd.AddressesURL))
+		} else {
+			metricSync.WithLabelValues("ok").Inc()
+			log.Debug("fetched address list for secondary mx domain", slog.String("domain", name), slog.Int64("size", n))
+		}
+	}
+}
+
+// Sync fetches the address list from the AddressesURL of the domain, and replaces
+// the AddressesFile with it, returning the size of the list.
+func Sync(ctx context.Context, log mlog.Log, sd config.SecondaryMXDomain) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", sd.AddressesURL, nil)
+	if err != nil {
+		return 0, fmt.Errorf("new request: %v", err)
+	}
+	req.Header.Set("User-Agent", "mox/"+moxvar.Version)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("http request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("http response status %s, expected 200 ok", resp.Status)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(sd.AddressesFile), filepath.Base(sd.AddressesFile)+".*")
+	if err != nil {
+		return 0, fmt.Errorf("creating temporary file: %v", err)
+	}
+	tmpPath := f.Name()
+	defer func() {
+		if f != nil {
+			err := f.Close()
+			log.Check(err, "closing temporary address list file")
+		}
+		if tmpPath != "" {
+			err := os.Remove(tmpPath)
+			log.Check(err, "removing temporary address list file")
+		}
+	}()
+	n, err := io.Copy(f, io.LimitReader(resp.Body, maxListSize+1))
+	if err != nil {
+		return 0, fmt.Errorf("reading response: %v", err)
+	} else if n > maxListSize {
+		return 0, fmt.Errorf("address list larger than maximum %d bytes", maxListSize)
+	}
+	if err := f.Sync(); err != nil {
+		return 0, fmt.Errorf("sync temporary file: %v", err)
+	}
+	err = f.Close()
+	f = nil
+	if err != nil {
+		return 0, fmt.Errorf("close temporary file: %v", err)
+	}
+	if err := os.Rename(tmpPath, sd.AddressesFile); err != nil {
+		return 0, fmt.Errorf("replacing address list file: %v", err)
+	}
+	tmpPath = ""
+	if err := moxio.SyncDir(log, filepath.Dir(sd.AddressesFile)); err != nil {
+		return 0, fmt.Errorf("sync dir after replacing address list file: %v", err)
+	}
+	return n, nil
+}
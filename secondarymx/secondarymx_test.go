@@ -0,0 +1,104 @@
+package secondarymx
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/smtp"
+)
+
+func tcheck(t *testing.T, err error, msg string) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %s", msg, err)
+	}
+}
+
+func TestAccepts(t *testing.T) {
+	d := dns.Domain{ASCII: "secondary.example"}
+	file := filepath.Join(t.TempDir(), "addresses.txt")
+	sd := config.SecondaryMXDomain{AddressesFile: file, LocalpartCatchallSeparators: []string{"+"}, Domain: d}
+
+	check := func(lp string, exp bool) {
+		t.Helper()
+		ok, err := Accepts(sd, smtp.NewAddress(smtp.Localpart(lp), d))
+		tcheck(t, err, "accepts")
+		if ok != exp {
+			t.Fatalf("accepts %q, got %v, expected %v", lp, ok, exp)
+		}
+	}
+
+	// Missing file is an error, we shouldn't accept or reject everything.
+	_, err := Accepts(sd, smtp.NewAddress("mjl", d))
+	if err == nil {
+		t.Fatalf("accepts without address list file, expected error")
+	}
+
+	err = os.WriteFile(file, []byte("# comment\n\nMjl@secondary.example\nbad address\nother@other.example\n"), 0660)
+	tcheck(t, err, "write address list")
+	check("mjl", true)
+	check("MJL", true)
+	check("mjl+tag", true)
+	check("other", false)
+
+	// File is read again after changes.
+	err = os.WriteFile(file, []byte("mjl@secondary.example\n@secondary.example\n"), 0660)
+	tcheck(t, err, "write address list")
+	check("other", true)
+
+	// Without address list, all addresses are accepted.
+	sd.AddressesFile = ""
+	check("unknown", true)
+}
+
+func TestSync(t *testing.T) {
+	log := mlog.New("secondarymx", nil)
+	const list = "mjl@secondary.example\n"
+	var status int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		fmt.Fprint(w, list)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "addresses.txt")
+	sd := config.SecondaryMXDomain{AddressesFile: file, AddressesURL: srv.URL, Domain: dns.Domain{ASCII: "secondary.example"}}
+
+	status = http.StatusOK
+	n, err := Sync(context.Background(), log, sd)
+	tcheck(t, err, "sync")
+	if n != int64(len(list)) {
+		t.Fatalf("sync, got size %d, expected %d", n, len(list))
+	}
+	buf, err := os.ReadFile(file)
+	tcheck(t, err, "read address list")
+	if string(buf) != list {
+		t.Fatalf("got address list %q, expected %q", buf, list)
+	}
+
+	// Error response keeps the previous list.
+	status = http.StatusInternalServerError
+	_, err = Sync(context.Background(), log, sd)
+	if err == nil {
+		t.Fatalf("sync with error response, expected error")
+	}
+	buf, err = os.ReadFile(file)
+	tcheck(t, err, "read address list")
+	if string(buf) != list {
+		t.Fatalf("got address list %q after failed sync, expected %q", buf, list)
+	}
+	entries, err := os.ReadDir(dir)
+	tcheck(t, err, "read dir")
+	if len(entries) != 1 {
+		t.Fatalf("got %d files in dir, expected only address list", len(entries))
+	}
+}
@@ -0,0 +1,376 @@
+// Package sendguard detects anomalies in messages submitted by accounts that
+// indicate a compromised account, e.g. through a stolen password, and throttles
+// or freezes the account and notifies the postmaster.
+//
+// Anomalies are a spike in the number of recipients compared to the hourly
+// average of the past week, a high rate of bounces (DSN messages received by the
+// account, and spam complaints in abuse reports) compared to the number of
+// recipients in the past 24 hours, and content classified as spam. Submissions
+// from a network (IPv4 /16, IPv6 /32) not used before by the account make the
+// limits stricter.
+package sendguard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/admindb"
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/spamscan"
+	"github.com/mjl-/mox/store"
+)
+
+var metricIncident = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "mox_sendguard_incident_total",
+		Help: "Anomalies detected in submissions by accounts, by action taken.",
+	},
+	[]string{
+		"action", // alert, throttle, freeze
+	},
+)
+
+var (
+	// ErrThrottled is returned when submissions by the account are refused for a while.
+	ErrThrottled = errors.New("submissions temporarily refused due to suspicious activity")
+
+	// ErrFrozen is returned when the account was frozen.
+	ErrFrozen = errors.New("account frozen due to suspicious activity")
+)
+
+// LoginDisabledMessage is set as LoginDisabled in the account configuration when
+// the account is frozen.
+const LoginDisabledMessage = "account frozen due to suspicious outgoing messages, contact the admin"
+
+// Only one incident per account is recorded, and the postmaster notified,
+// within this interval, e.g. for repeated anomalies with action alert.
+const incidentInterval = time.Hour
+
+// Check looks for anomalies in a message submitted by acc from remoteIP for
+// rcpts, before it is queued. Source is "smtp", "webmail" or "webapi". If the
+// account is throttled, or a detected anomaly results in a throttle or freeze, an
+// error wrapping ErrThrottled or ErrFrozen is returned, and the message must not
+// be queued. Internal errors are logged and don't cause a refusal.
+func Check(ctx context.Context, log mlog.Log, acc *store.Account, source string, remoteIP net.IP, rcpts []smtp.Path, msg io.ReaderAt, size int64) error {
+	conf := mox.Conf.Static.SubmissionGuard
+	if conf == nil {
+		return nil
+	}
+	log = log.With(slog.String("account", acc.Name))
+
+	if si, err := admindb.SubmissionThrottled(ctx, acc.Name); err == nil {
+		return fmt.Errorf("%w, until %s", ErrThrottled, si.Until.Format(time.RFC3339))
+	} else if !errors.Is(err, admindb.ErrNotFound) {
+		log.Errorx("checking whether account is throttled", err)
+	}
+
+	var newNetwork bool
+	network := Network(remoteIP)
+	if network != "" {
+		isNew, haveOthers, err := admindb.SubmissionNetworkSeen(ctx, acc.Name, network)
+		log.Check(err, "recording submission network")
+		newNetwork = isNew && haveOthers
+	}
+
+	anomalies, err := detect(ctx, log, acc, conf, newNetwork, rcpts, msg, size)
+	if err != nil {
+		log.Errorx("detecting anomalies in submission", err)
+		return nil
+	}
+	if len(anomalies) == 0 {
+		return nil
+	}
+	if newNetwork {
+		anomalies = append(anomalies, fmt.Sprintf("submission from network %s, not used before by account", network))
+	}
+	log.Info("anomalies detected in submission", slog.Any("anomalies", anomalies), slog.Any("remoteip", remoteIP))
+
+	l, err := admindb.SubmissionIncidentList(ctx, acc.Name, 1)
+	if err != nil {
+		log.Errorx("listing recent submission incidents", err)
+	} else if len(l) > 0 && l[0].Action == "alert" && time.Since(l[0].Time) < incidentInterval {
+		return nil
+	}
+
+	action := conf.Action
+	if action == "" {
+		action = "throttle"
+	}
+	si := admindb.SubmissionIncident{
+		Account:   acc.Name,
+		Source:    source,
+		Anomalies: anomalies,
+		Action:    action,
+	}
+	if remoteIP != nil {
+		si.RemoteIP = remoteIP.String()
+	}
+	var rerr error
+	switch action {
+	case "throttle":
+		d := conf.ThrottleDuration
+		if d == 0 {
+			d = time.Hour
+		}
+		si.Until = time.Now().Add(d)
+		rerr = fmt.Errorf("%w, until %s", ErrThrottled, si.Until.Format(time.RFC3339))
+	case "freeze":
+		freeze(context.WithoutCancel(ctx), log, acc)
+		rerr = ErrFrozen
+	}
+	metricIncident.WithLabelValues(action).Inc()
+	err = admindb.SubmissionIncidentAdd(ctx, &si)
+	log.Check(err, "adding submission incident")
+	err = notify(log, si)
+	log.Check(err, "notifying postmaster about submission incident")
+	return rerr
+}
+
+// Network returns the network of ip used for recognizing new networks: an IPv4
+// /16 or an IPv6 /32. For loopback IPs, an empty string is returned.
+func Network(ip net.IP) string {
+	if ip == nil || ip.IsLoopback() {
+		return ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return (&net.IPNet{IP: ip4.Mask(net.CIDRMask(16, 32)), Mask: net.CIDRMask(16, 32)}).String()
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(32, 128)), Mask: net.CIDRMask(32, 128)}).String()
+}
+
+// detect returns descriptions of anomalies.
+func detect(ctx context.Context, log mlog.Log, acc *store.Account, conf *config.SubmissionGuard, newNetwork bool, rcpts []smtp.Path, msg io.ReaderAt, size int64) ([]string, error) {
+	spikeFactor := conf.RecipientSpikeFactor
+	if spikeFactor == 0 {
+		spikeFactor = 10
+	}
+	spikeMin := conf.RecipientSpikeMin
+	if spikeMin == 0 {
+		spikeMin = 50
+	}
+	bounceRate := conf.BounceRate
+	if bounceRate == 0 {
+		bounceRate = 0.2
+	}
+	bounceMin := conf.BounceMin
+	if bounceMin == 0 {
+		bounceMin = 20
+	}
+	if newNetwork {
+		spikeFactor /= 2
+		spikeMin /= 2
+		bounceRate /= 2
+		bounceMin /= 2
+	}
+
+	now := time.Now()
+	var hour, day, week, bounces int
+	err := acc.DB.Read(ctx, func(tx *bstore.Tx) error {
+		err := bstore.QueryTx[store.Outgoing](tx).FilterGreater("Submitted", now.Add(-7*24*time.Hour)).ForEach(func(o store.Outgoing) error {
+			week++
+			if o.Submitted.After(now.Add(-24 * time.Hour)) {
+				day++
+			}
+			if o.Submitted.After(now.Add(-time.Hour)) {
+				hour++
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("counting recipients: %w", err)
+		}
+		if day+len(rcpts) < bounceMin {
+			return nil
+		}
+
+		// Count DSNs per mailbox, so we can use the index on MailboxID+Received.
+		mailboxes, err := bstore.QueryTx[store.Mailbox](tx).List()
+		if err != nil {
+			return fmt.Errorf("listing mailboxes: %w", err)
+		}
+		for _, mb := range mailboxes {
+			q := bstore.QueryTx[store.Message](tx)
+			q.FilterNonzero(store.Message{MailboxID: mb.ID, DSN: true})
+			q.FilterGreater("Received", now.Add(-24*time.Hour))
+			q.FilterEqual("Expunged", false)
+			n, err := q.Count()
+			if err != nil {
+				return fmt.Errorf("counting dsns: %w", err)
+			}
+			bounces += n
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Spam complaints received in abuse reports count as bounces.
+	var complaints int
+	if day+len(rcpts) >= bounceMin {
+		complaints, err = admindb.AbuseComplaintsSince(ctx, acc.Name, now.Add(-24*time.Hour))
+		if err != nil {
+			return nil, fmt.Errorf("counting spam complaints: %w", err)
+		}
+	}
+
+	var anomalies []string
+	hour += len(rcpts)
+	day += len(rcpts)
+	avg := float64(week) / (7 * 24)
+	if hour >= spikeMin && float64(hour) > spikeFactor*avg {
+		anomalies = append(anomalies, fmt.Sprintf("recipient spike: %d recipients in past hour, hourly average %.1f in past week", hour, avg))
+	}
+	if day >= bounceMin && float64(bounces+complaints) > bounceRate*float64(day) {
+		if complaints > 0 {
+			anomalies = append(anomalies, fmt.Sprintf("high bounce rate: %d bounces and %d spam complaints for %d recipients in past 24 hours", bounces, complaints, day))
+		} else {
+			anomalies = append(anomalies, fmt.Sprintf("high bounce rate: %d bounces for %d recipients in past 24 hours", bounces, day))
+		}
+	}
+
+	if conf.ContentCheck {
+		spam, descr, err := classify(ctx, log, acc, msg, size)
+		if err != nil {
+			log.Errorx("classifying submitted message, continuing", err)
+		} else if spam {
+			anomalies = append(anomalies, "spammy content: "+descr)
+		}
+	}
+	return anomalies, nil
+}
+
+// classify checks the message with the external spam scanners if configured, or
+// with the junk filter of the account otherwise.
+func classify(ctx context.Context, log mlog.Log, acc *store.Account, msg io.ReaderAt, size int64) (bool, string, error) {
+	if sc := mox.Conf.Static.SpamScan; sc != nil {
+		var scanners []spamscan.Scanner
+		if sc.Rspamd != nil {
+			scanners = append(scanners, spamscan.Rspamd{URL: sc.Rspamd.URL, Password: sc.Rspamd.Password})
+		}
+		if sc.Spamd != nil {
+			scanners = append(scanners, spamscan.Spamd{Address: sc.Spamd.Address, User: sc.Spamd.User})
+		}
+		timeout := sc.Timeout
+		if timeout == 0 {
+			timeout = 15 * time.Second
+		}
+		meta := spamscan.Meta{User: acc.Name}
+		for _, s := range scanners {
+			scanctx, scancancel := context.WithTimeout(ctx, timeout)
+			r, err := s.Scan(scanctx, log, meta, io.NewSectionReader(msg, 0, size), size)
+			scancancel()
+			if err != nil {
+				return false, "", err
+			} else if r.Spam {
+				return true, fmt.Sprintf("%s score %.1f, required %.1f", r.Scanner, r.Score, r.Required), nil
+			}
+		}
+		return false, "", nil
+	}
+
+	f, jf, err := acc.OpenJunkFilter(ctx, log)
+	if errors.Is(err, store.ErrNoJunkFilter) {
+		return false, "", nil
+	} else if err != nil {
+		return false, "", err
+	}
+	defer func() {
+		err := f.Close()
+		log.Check(err, "closing junk filter")
+	}()
+	prob, _, nham, nspam, err := f.ClassifyMessageReader(ctx, msg, size)
+	if err != nil {
+		return false, "", err
+	}
+	if nham+nspam > 0 && prob > jf.Threshold {
+		return true, fmt.Sprintf("junk filter probability %.2f, threshold %.2f", prob, jf.Threshold), nil
+	}
+	return false, "", nil
+}
+
+// freeze disables logins to the account, holds its messages in the queue and
+// closes its sessions.
+func freeze(ctx context.Context, log mlog.Log, acc *store.Account) {
+	err := mox.AccountSave(ctx, acc.Name, func(a *config.Account) {
+		a.LoginDisabled = LoginDisabledMessage
+	})
+	log.Check(err, "disabling logins for frozen account")
+	_, err = queue.HoldRuleAdd(ctx, log, queue.HoldRule{Account: acc.Name})
+	log.Check(err, "adding queue hold rule for frozen account")
+	_, err = acc.ProtocolSessionClose(log, 0)
+	log.Check(err, "closing sessions of frozen account")
+}
+
+// notify delivers a message about the incident to the postmaster.
+func notify(log mlog.Log, si admindb.SubmissionIncident) error {
+	var action string
+	switch si.Action {
+	case "alert":
+		action = "No action was taken, submissions are still accepted."
+	case "throttle":
+		action = fmt.Sprintf("Submissions by the account are refused until %s. The throttle\ncan be cleared in the admin web interface.", si.Until.Format(time.RFC3339))
+	case "freeze":
+		action = `Logins to the account are disabled, its sessions closed, and its messages
+in the queue are held. After securing the account, e.g. by changing its
+password, enable logins again in the admin web interface, and release or
+remove the held messages from the queue.`
+	}
+	text := fmt.Sprintf(`Hi!
+
+Suspicious activity was detected in messages submitted by account %s,
+through %s from IP %s, which may indicate the account is compromised:
+
+- %s
+
+%s
+
+Cheers,
+mox
+`, si.Account, si.Source, si.RemoteIP, strings.Join(si.Anomalies, "\n- "), action)
+
+	a, err := store.OpenAccount(log, mox.Conf.Static.Postmaster.Account)
+	if err != nil {
+		return fmt.Errorf("open postmaster account: %v", err)
+	}
+	defer func() {
+		err := a.Close()
+		log.Check(err, "closing account")
+	}()
+	f, err := store.CreateMessageTemp(log, "sendguard")
+	if err != nil {
+		return fmt.Errorf("creating temporary message file: %v", err)
+	}
+	defer store.CloseRemoveTempFile(log, f, "message for submission incident notification")
+
+	m := store.Message{
+		Received: time.Now(),
+		Flags:    store.Flags{Flagged: true},
+	}
+	n, err := fmt.Fprintf(f, "Date: %s\r\nSubject: mox: suspicious submissions by account %s\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: 8-bit\r\n\r\n%s", time.Now().Format(message.RFC5322Z), si.Account, strings.ReplaceAll(text, "\n", "\r\n"))
+	if err != nil {
+		return fmt.Errorf("writing temporary message file: %v", err)
+	}
+	m.Size = int64(n)
+
+	a.WithWLock(func() {
+		err = a.DeliverMailbox(log, mox.Conf.Static.Postmaster.Mailbox, &m, f)
+	})
+	return err
+}
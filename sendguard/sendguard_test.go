@@ -0,0 +1,172 @@
+package sendguard
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/admindb"
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/store"
+)
+
+var ctxbg = context.Background()
+
+func tcheck(t *testing.T, err error, msg string) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %s", msg, err)
+	}
+}
+
+func TestNetwork(t *testing.T) {
+	test := func(ip, expect string) {
+		t.Helper()
+		if s := Network(net.ParseIP(ip)); s != expect {
+			t.Fatalf("network for %s: got %q, expected %q", ip, s, expect)
+		}
+	}
+	test("198.51.100.10", "198.51.0.0/16")
+	test("2001:db8:1:2::1", "2001:db8::/32")
+	test("127.0.0.1", "")
+	test("::1", "")
+}
+
+func TestCheck(t *testing.T) {
+	log := mlog.New("sendguard", nil)
+	os.RemoveAll("../testdata/sendguard/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/sendguard/mox.conf")
+	mox.ConfigDynamicPath = filepath.FromSlash("../testdata/sendguard/domains.conf")
+	mox.MustLoadConfig(true, false)
+	defer store.Switchboard()()
+	err := queue.Init()
+	tcheck(t, err, "queue init")
+	defer queue.Shutdown()
+	err = admindb.Init()
+	tcheck(t, err, "admindb init")
+	defer admindb.Close()
+
+	acc, err := store.OpenAccount(log, "mjl")
+	tcheck(t, err, "open account")
+	defer func() {
+		err := acc.Close()
+		tcheck(t, err, "close account")
+		acc.CheckClosed()
+	}()
+
+	const msg = "From: <mjl@mox.example>\r\nSubject: test\r\n\r\nbody\r\n"
+	rcpts := func(n int) []smtp.Path {
+		var l []smtp.Path
+		for i := 0; i < n; i++ {
+			l = append(l, smtp.Path{Localpart: "remote", IPDomain: dns.IPDomain{Domain: dns.Domain{ASCII: "example.org"}}})
+		}
+		return l
+	}
+	check := func(ip string, n int) error {
+		t.Helper()
+		return Check(ctxbg, log, acc, "smtp", net.ParseIP(ip), rcpts(n), strings.NewReader(msg), int64(len(msg)))
+	}
+
+	// Without configuration, nothing is checked.
+	err = check("198.51.100.1", 100)
+	tcheck(t, err, "check without config")
+
+	mox.Conf.Static.SubmissionGuard = &config.SubmissionGuard{RecipientSpikeMin: 10}
+	defer func() {
+		mox.Conf.Static.SubmissionGuard = nil
+	}()
+
+	err = check("198.51.100.1", 5)
+	tcheck(t, err, "check below limit")
+
+	// Limits are halved for a network not used before.
+	err = check("203.0.113.1", 5)
+	if !errors.Is(err, ErrThrottled) {
+		t.Fatalf("got err %v, expected ErrThrottled for spike from new network", err)
+	}
+	// While throttled, all submissions are refused.
+	err = check("198.51.100.1", 1)
+	if !errors.Is(err, ErrThrottled) {
+		t.Fatalf("got err %v, expected ErrThrottled while throttled", err)
+	}
+
+	l, err := admindb.SubmissionIncidentList(ctxbg, "mjl", 0)
+	tcheck(t, err, "list incidents")
+	if len(l) != 1 || l[0].Action != "throttle" || l[0].RemoteIP != "203.0.113.1" || len(l[0].Anomalies) != 2 {
+		t.Fatalf("got incidents %#v, expected single throttle with 2 anomalies", l)
+	}
+
+	n, err := admindb.SubmissionThrottleClear(ctxbg, "mjl")
+	tcheck(t, err, "clear throttle")
+	if n != 1 {
+		t.Fatalf("cleared %d throttles, expected 1", n)
+	}
+	err = check("198.51.100.1", 1)
+	tcheck(t, err, "check after clearing throttle")
+
+	// Postmaster was notified.
+	err = acc.DB.Read(ctxbg, func(tx *bstore.Tx) error {
+		mb, err := acc.MailboxFind(tx, "postmaster")
+		if err != nil || mb == nil {
+			t.Fatalf("finding postmaster mailbox: %v", err)
+		}
+		n, err := bstore.QueryTx[store.Message](tx).FilterNonzero(store.Message{MailboxID: mb.ID}).Count()
+		if err == nil && n != 1 {
+			t.Fatalf("got %d messages in postmaster mailbox, expected 1", n)
+		}
+		return err
+	})
+	tcheck(t, err, "checking postmaster notification")
+
+	// Spam complaints from abuse reports count as bounces.
+	for i := 0; i < 3; i++ {
+		err = admindb.AbuseReportAdd(ctxbg, &admindb.AbuseReport{Account: "mjl", FeedbackType: "abuse"})
+		tcheck(t, err, "add abuse report")
+	}
+	mox.Conf.Static.SubmissionGuard = &config.SubmissionGuard{RecipientSpikeMin: 1000, BounceMin: 1, BounceRate: 0.01}
+	err = check("198.51.100.1", 1)
+	if !errors.Is(err, ErrThrottled) {
+		t.Fatalf("got err %v, expected ErrThrottled for spam complaints", err)
+	}
+	l, err = admindb.SubmissionIncidentList(ctxbg, "mjl", 0)
+	tcheck(t, err, "list incidents")
+	if len(l) != 2 || !slices.ContainsFunc(l[0].Anomalies, func(s string) bool { return strings.Contains(s, "3 spam complaints") }) {
+		t.Fatalf("got incidents %#v, expected incident for spam complaints", l)
+	}
+	_, err = admindb.SubmissionThrottleClear(ctxbg, "mjl")
+	tcheck(t, err, "clear throttle")
+	mox.Conf.Static.SubmissionGuard = &config.SubmissionGuard{RecipientSpikeMin: 10}
+
+	// Freezing an account disables logins and holds its messages in the queue.
+	mox.Conf.Static.SubmissionGuard.Action = "freeze"
+	err = check("198.51.100.1", 20)
+	if !errors.Is(err, ErrFrozen) {
+		t.Fatalf("got err %v, expected ErrFrozen", err)
+	}
+	defer func() {
+		err := mox.AccountSave(ctxbg, "mjl", func(a *config.Account) {
+			a.LoginDisabled = ""
+		})
+		tcheck(t, err, "enabling logins again")
+	}()
+	if accConf, _ := mox.Conf.Account("mjl"); accConf.LoginDisabled != LoginDisabledMessage {
+		t.Fatalf("got login disabled %q, expected %q", accConf.LoginDisabled, LoginDisabledMessage)
+	}
+	hrl, err := queue.HoldRuleList(ctxbg)
+	tcheck(t, err, "list hold rules")
+	if len(hrl) != 1 || hrl[0].Account != "mjl" {
+		t.Fatalf("got hold rules %#v, expected rule for account", hrl)
+	}
+}
@@ -3,20 +3,33 @@ package main
 import (
 	"fmt"
 	"os"
+	"slices"
 	"time"
 
+	"github.com/mjl-/mox/accountdel"
+	"github.com/mjl-/mox/accounting"
+	"github.com/mjl-/mox/admindb"
+	"github.com/mjl-/mox/alert"
 	"github.com/mjl-/mox/dmarcdb"
 	"github.com/mjl-/mox/dns"
 	"github.com/mjl-/mox/http"
 	"github.com/mjl-/mox/imapserver"
 	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/moderation"
 	"github.com/mjl-/mox/mox-"
 	"github.com/mjl-/mox/mtastsdb"
+	"github.com/mjl-/mox/mtastspromote"
+	"github.com/mjl-/mox/quarantine"
 	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/retention"
+	"github.com/mjl-/mox/secondarymx"
 	"github.com/mjl-/mox/smtpserver"
 	"github.com/mjl-/mox/store"
 	"github.com/mjl-/mox/tlsrptdb"
 	"github.com/mjl-/mox/tlsrptsend"
+	"github.com/mjl-/mox/tracing"
+	"github.com/mjl-/mox/webadmin"
+	"github.com/mjl-/mox/webpush"
 )
 
 func shutdown(log mlog.Log) {
@@ -47,13 +60,45 @@ func shutdown(log mlog.Log) {
 			log.Print("shutting down with pending sockets")
 		}
 	}
+	// Export spans of the connections that were just closed.
+	tracing.Stop()
+
 	err := os.Remove(mox.DataDirPath("ctl"))
 	log.Check(err, "removing ctl unix domain socket during shutdown")
 }
 
+// configureTracing starts exporting traces if configured, or stops exporting if
+// tracing was disabled by a reload of the config file.
+func configureTracing() {
+	t := mox.Conf.Static.Tracing
+	if t == nil {
+		tracing.Stop()
+		return
+	}
+	tracing.Configure(nil, tracing.Config{
+		Endpoint:    t.Endpoint,
+		Headers:     t.Headers,
+		ServiceName: t.ServiceName,
+		SampleRatio: t.SampleRatio,
+	})
+}
+
+// staticReloaded reconfigures packages after changes to the static config file
+// were applied while running.
+func staticReloaded(log mlog.Log, changed []string) {
+	if slices.Contains(changed, "LogFormat") {
+		mlog.JSON = mox.Conf.Static.LogFormat == "json"
+	}
+	if slices.Contains(changed, "Tracing") {
+		configureTracing()
+	}
+}
+
 // start initializes all packages, starts all listeners and the switchboard
 // goroutine, then returns.
 func start(mtastsdbRefresher, sendDMARCReports, sendTLSReports, skipForkExec bool) error {
+	mox.InitDNSCache()
+
 	smtpserver.Listen()
 	imapserver.Listen()
 	http.Listen()
@@ -78,8 +123,16 @@ func start(mtastsdbRefresher, sendDMARCReports, sendTLSReports, skipForkExec boo
 		return fmt.Errorf("tlsrpt init: %s", err)
 	}
 
+	if err := admindb.Init(); err != nil {
+		return fmt.Errorf("admindb init: %s", err)
+	}
+
+	// Before starting the queue, so deliveries are traced.
+	configureTracing()
+	mox.StaticReloaded = staticReloaded
+
 	done := make(chan struct{}, 4) // Goroutines for messages and webhooks, and cleaners.
-	if err := queue.Start(dns.StrictResolver{Pkg: "queue"}, done); err != nil {
+	if err := queue.Start(mox.CachingResolver(dns.StrictResolver{Pkg: "queue"}), done); err != nil {
 		return fmt.Errorf("queue start: %s", err)
 	}
 
@@ -95,10 +148,39 @@ func start(mtastsdbRefresher, sendDMARCReports, sendTLSReports, skipForkExec boo
 		tlsrptsend.Start(dns.StrictResolver{Pkg: "tlsrptsend"})
 	}
 
+	webpush.Start()
+	retention.Start()
+	accountdel.Start()
+	quarantine.Start()
+	moderation.Start()
+	webadmin.StartDNSCheck()
+	alert.Start()
+	secondarymx.Start()
+	mtastspromote.Start()
+	accounting.Start()
+	for _, acme := range mox.Conf.Static.ACME {
+		if acme.Manager != nil {
+			acme.Manager.Start(mlog.New("autotls", nil))
+		}
+	}
+	for _, l := range mox.Conf.Static.Listeners {
+		if l.TLS != nil && l.TLS.OCSPStapler != nil {
+			l.TLS.OCSPStapler.Start(mlog.New("ocsp", nil), mox.Shutdown.Done())
+		}
+	}
+
 	store.StartAuthCache()
+	if mox.Conf.Static.DeduplicateMessages {
+		store.StartDedupCleanup()
+	}
+	if mox.Conf.Static.Scrub != nil {
+		store.StartScrub()
+	}
+	store.StartRetrainBacklog()
 	smtpserver.Serve()
 	imapserver.Serve()
 	http.Serve()
+	mox.Started.Store(true)
 
 	go func() {
 		store.Switchboard()
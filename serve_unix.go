@@ -56,8 +56,6 @@ func monitorDNSBL(log mlog.Log) {
 		}
 	}()
 
-	publicListener := mox.Conf.Static.Listeners["public"]
-
 	// We keep track of the previous metric values, so we can delete those we no longer
 	// monitor.
 	type key struct {
@@ -84,16 +82,8 @@ func monitorDNSBL(log mlog.Log) {
 		lastConns = conns
 		last = time.Now()
 
-		// Gather zones.
-		zones := append([]dns.Domain{}, publicListener.SMTP.DNSBLZones...)
-		conf := mox.Conf.DynamicConfig()
-		for _, zone := range conf.MonitorDNSBLZones {
-			if !slices.Contains(zones, zone) {
-				zones = append(zones, zone)
-			}
-		}
-		// And gather IPs.
-		ips, err := mox.IPs(mox.Context, false)
+		zones := dnsblMonitorZones()
+		publicIPs, err := dnsblMonitorIPs(mox.Context)
 		if err != nil {
 			log.Errorx("listing ips for dnsbl monitor", err)
 			// Mark checks as broken.
@@ -102,13 +92,8 @@ func monitorDNSBL(log mlog.Log) {
 			}
 			continue
 		}
-		var publicIPs []net.IP
 		var publicIPstrs []string
-		for _, ip := range ips {
-			if ip.IsLoopback() || ip.IsPrivate() {
-				continue
-			}
-			publicIPs = append(publicIPs, ip)
+		for _, ip := range publicIPs {
 			publicIPstrs = append(publicIPstrs, ip.String())
 		}
 
@@ -123,7 +108,7 @@ func monitorDNSBL(log mlog.Log) {
 		// Do DNSBL checks and update metric.
 		for _, ip := range publicIPs {
 			for _, zone := range zones {
-				status, expl, err := dnsbl.Lookup(mox.Context, log.Logger, resolver, zone, ip)
+				status, expl, err := dnsblMonitorLookup(mox.Context, log, resolver, zone, ip)
 				if err != nil {
 					log.Errorx("dnsbl monitor lookup", err,
 						slog.Any("ip", ip),
@@ -219,6 +204,15 @@ Only implemented on unix systems, not Windows.
 			slog.Any("pid", os.Getpid()))
 	}
 
+	configureLogging(log)
+
+	// The config is already migrated in memory, write it so the migration is done once.
+	if m, err := mox.ConfigMigrate(log, false); err != nil {
+		log.Errorx("migrating domains.conf to current version, continuing with config migrated in memory", err)
+	} else if m.Written {
+		log.Print("migrated domains.conf to current version", slog.Any("changes", m.Changes))
+	}
+
 	syscall.Umask(syscall.Umask(007) | 007)
 
 	// Initialize key and random buffer for creating opaque SMTP
@@ -408,6 +402,18 @@ Only implemented on unix systems, not Windows.
 		}
 	}
 
+	// Reload the config file on SIGHUP, applying changes that don't require a
+	// restart, and resetting log levels, e.g. changed through the admin web
+	// interface, to those in the config file.
+	hupc := make(chan os.Signal, 1)
+	signal.Notify(hupc, syscall.SIGHUP)
+	go func() {
+		for range hupc {
+			_, err := mox.Conf.ReloadStatic(context.Background(), log, true)
+			log.Check(err, "reloading config file after sighup")
+		}
+	}()
+
 	// Graceful shutdown.
 	sigc := make(chan os.Signal, 1)
 	signal.Notify(sigc, os.Interrupt, syscall.SIGTERM)
@@ -421,6 +427,30 @@ Only implemented on unix systems, not Windows.
 	}
 }
 
+// configureLogging sets the log output format, the optional syslog destination
+// and the size of the in-memory buffer with recent log lines from the config.
+func configureLogging(log mlog.Log) {
+	mlog.JSON = mox.Conf.Static.LogFormat == "json"
+
+	if ls := mox.Conf.Static.LogSyslog; ls != nil {
+		tag := ls.Tag
+		if tag == "" {
+			tag = "mox"
+		}
+		err := mlog.SetSyslog(ls.Network, ls.Address, tag)
+		log.Check(err, "setting up logging to syslog, continuing with logging to stderr")
+	}
+
+	n := mox.Conf.Static.LogRecent
+	switch n {
+	case 0:
+		n = 10000
+	case -1:
+		n = 0
+	}
+	mlog.SetRecent(n)
+}
+
 // Set correct permissions for mox working directory, binary, config and data and service file.
 //
 // We require being able to stat the basic non-optional paths. Then we'll try to
@@ -88,8 +88,8 @@ var dotcrlf = []byte(".\r\n")
 // DataReader is an io.Reader that reads data from an SMTP DATA command, doing dot
 // unstuffing and returning io.EOF when a bare dot is received. Use NewDataReader.
 //
-// Bare carriage returns, and the sequences "[^\r]\n." and "\n.\n" result in an
-// error.
+// Line ending anomalies are counted while reading. Depending on the Reject
+// fields, anomalies result in ErrCRLF at the end of the data.
 type DataReader struct {
 	// ../rfc/5321:2003
 	r           *bufio.Reader
@@ -97,10 +97,20 @@ type DataReader struct {
 	buf         []byte // From previous read.
 	err         error  // Read error, for after r.buf is exhausted.
 
-	// When we see invalid combinations of CR and LF, we keep reading, and report an
-	// error at the final "\r\n.\r\n". We cannot just stop reading and return an error,
-	// the SMTP protocol would become out of sync.
-	badcrlf bool
+	// Counts of line ending anomalies seen so far. When we see anomalies, we keep
+	// reading, and report an error at the final "\r\n.\r\n" if they are rejected. We
+	// cannot just stop reading and return an error, the SMTP protocol would become out
+	// of sync.
+	BareCR     int // Carriage returns not followed by a newline.
+	BareLF     int // Newlines not preceded by a carriage return.
+	BadDataEnd int // Dots on a line with other line endings than CRLF, e.g. "\n.\n", interpreted by some mail servers as end of data. Used for SMTP smuggling.
+
+	// Whether anomalies result in ErrCRLF. NewDataReader rejects bare carriage returns
+	// and bad data endings. Bare newlines are accepted by default, real-world messages
+	// like that occur, and the SMTP server adds missing carriage returns.
+	RejectBareCR     bool
+	RejectBareLF     bool
+	RejectBadDataEnd bool
 }
 
 // NewDataReader returns an initialized DataReader.
@@ -108,8 +118,37 @@ func NewDataReader(r *bufio.Reader) *DataReader {
 	return &DataReader{
 		r: r,
 		// Set up initial state to accept a message that is only "." and CRLF.
-		plast: '\r',
-		last:  '\n',
+		plast:            '\r',
+		last:             '\n',
+		RejectBareCR:     true,
+		RejectBadDataEnd: true,
+	}
+}
+
+// Rejected returns whether anomalies were seen that are rejected.
+func (r *DataReader) Rejected() bool {
+	return r.RejectBareCR && r.BareCR > 0 || r.RejectBareLF && r.BareLF > 0 || r.RejectBadDataEnd && r.BadDataEnd > 0
+}
+
+// scan counts the line ending anomalies in buf, a newly read line or part of a
+// line, following previously read data.
+func (r *DataReader) scan(buf []byte) {
+	p2, p1 := r.plast, r.last
+	for i, c := range buf {
+		if p1 == '\r' && c != '\n' {
+			r.BareCR++
+		}
+		if c == '\n' && p1 != '\r' {
+			r.BareLF++
+		}
+		// A dot on its own line, with other line endings than the CRLF we require for the
+		// end of data. ../rfc/5321:2032
+		if c == '.' && (p1 == '\r' || p1 == '\n') && i+1 < len(buf) && (buf[i+1] == '\r' || buf[i+1] == '\n') {
+			if p2 != '\r' || p1 != '\n' || i+2 >= len(buf) || buf[i+1] != '\r' || buf[i+2] != '\n' {
+				r.BadDataEnd++
+			}
+		}
+		p2, p1 = p1, c
 	}
 }
 
@@ -131,39 +170,26 @@ func (r *DataReader) Read(p []byte) (int, error) {
 				// io.EOF again.
 				r.err = io.ErrUnexpectedEOF
 			}
-		}
-		if len(r.buf) > 0 {
-			// Reject bare \r.
-			for i, c := range r.buf {
-				if c == '\r' && (i == len(r.buf) || r.buf[i+1] != '\n') {
-					r.badcrlf = true
-				}
-			}
+			if len(r.buf) > 0 {
+				r.scan(r.buf)
 
-			// We require crlf. A bare LF is not a line ending for the end of the SMTP
-			// transaction. ../rfc/5321:2032
-			// Bare newlines are accepted as message data, unless around a bare dot. The SMTP
-			// server adds missing carriage returns. We don't reject bare newlines outright,
-			// real-world messages like that occur.
-			if r.plast == '\r' && r.last == '\n' {
-				if bytes.Equal(r.buf, dotcrlf) {
-					r.buf = nil
-					r.err = io.EOF
-					if r.badcrlf {
-						r.err = ErrCRLF
-					}
-					break
-				} else if r.buf[0] == '.' {
-					// Reject "\r\n.\n".
-					if len(r.buf) >= 2 && r.buf[1] == '\n' {
-						r.badcrlf = true
+				// We require crlf. A bare LF is not a line ending for the end of the SMTP
+				// transaction. ../rfc/5321:2032
+				if r.plast == '\r' && r.last == '\n' {
+					if bytes.Equal(r.buf, dotcrlf) {
+						r.buf = nil
+						r.err = io.EOF
+						if r.Rejected() {
+							r.err = ErrCRLF
+						}
+						break
+					} else if r.buf[0] == '.' {
+						r.buf = r.buf[1:]
 					}
-					r.buf = r.buf[1:]
 				}
-			} else if r.last == '\n' && (bytes.HasPrefix(r.buf, []byte(".\n")) || bytes.HasPrefix(r.buf, []byte(".\r\n"))) {
-				// Reject "[^\r]\n.\n" and "[^\r]\n.\r\n"
-				r.badcrlf = true
 			}
+		}
+		if len(r.buf) > 0 {
 			n := len(r.buf)
 			if n > len(p) {
 				n = len(p)
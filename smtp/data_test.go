@@ -123,6 +123,27 @@ func TestDataReader(t *testing.T) {
 	check("\r\n.\ris rejected\r\n.\r\n", "", ErrCRLF)
 	check("\r\n.\nis rejected\r\n.\r\n", "", ErrCRLF)
 
+	// Anomalies are counted, and rejecting can be configured.
+	counts := func(data string, rejectBareCR, rejectBareLF, rejectBadDataEnd bool, bareCR, bareLF, badDataEnd int, expErr error) {
+		t.Helper()
+		dr := NewDataReader(bufio.NewReader(strings.NewReader(data)))
+		dr.RejectBareCR = rejectBareCR
+		dr.RejectBareLF = rejectBareLF
+		dr.RejectBadDataEnd = rejectBadDataEnd
+		_, err := io.Copy(io.Discard, dr)
+		if err != expErr {
+			t.Fatalf("got err %v, expected %v, for %q", err, expErr, data)
+		}
+		if dr.BareCR != bareCR || dr.BareLF != bareLF || dr.BadDataEnd != badDataEnd {
+			t.Fatalf("got bare cr %d, bare lf %d, bad data end %d, expected %d, %d, %d, for %q", dr.BareCR, dr.BareLF, dr.BadDataEnd, bareCR, bareLF, badDataEnd, data)
+		}
+	}
+	counts("a\nb\n\r\n.\r\n", true, false, true, 0, 2, 0, nil)
+	counts("a\nb\n\r\n.\r\n", true, true, true, 0, 2, 0, ErrCRLF)
+	counts("a\rb\r\n.\r\n", false, false, false, 1, 0, 0, nil)
+	counts("a\r\n.\nb\n.\r\nc\r.\rd\r\n.\r\n", false, false, false, 2, 2, 3, nil)
+	counts("a\r\n.\nb\r\n.\r\n", false, false, true, 0, 1, 1, ErrCRLF)
+
 	s := &strings.Builder{}
 	dr := NewDataReader(bufio.NewReader(strings.NewReader("no end")))
 	if _, err := io.Copy(s, dr); err != io.ErrUnexpectedEOF {
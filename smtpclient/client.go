@@ -39,6 +39,7 @@ import (
 	"log/slog"
 	"net"
 	"reflect"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -53,6 +54,7 @@ import (
 	"github.com/mjl-/mox/smtp"
 	"github.com/mjl-/mox/stub"
 	"github.com/mjl-/mox/tlsrpt"
+	"github.com/mjl-/mox/tracing"
 )
 
 // todo future: add function to deliver message to multiple recipients. requires more elaborate return value, indicating success per message: some recipients may succeed, others may fail, and we should still deliver. to prevent backscatter, we also sometimes don't allow multiple recipients. ../rfc/5321:1144
@@ -143,6 +145,7 @@ type Client struct {
 	extSMTPUTF8           bool              // Remote server supports SMTPUTF8 extension.
 	extAuthMechanisms     []string          // Supported authentication mechanisms.
 	extRequireTLS         bool              // Remote supports REQUIRETLS extension.
+	extXClient            bool              // Remote supports XCLIENT extension, for attributes ADDR and HELO.
 	ExtLimits             map[string]string // For LIMITS extension, only if present and valid, with uppercase keys.
 	ExtLimitMailMax       int               // Max "MAIL" commands in a connection, if > 0.
 	ExtLimitRcptMax       int               // Max "RCPT" commands in a transaction, if > 0.
@@ -231,6 +234,21 @@ type Opts struct {
 	// tracked.
 	RecipientDomainResult *tlsrpt.Result // MTA-STS or no policy.
 	HostResult            *tlsrpt.Result // DANE or no policy.
+
+	// If not nil, the XCLIENT command is sent after STARTTLS and before
+	// authentication, to make the remote server treat the connection as coming from
+	// the original SMTP client of a message that is being forwarded, e.g. by a
+	// frontend node to a storage node. The remote server must announce the XCLIENT
+	// extension with the ADDR and HELO attributes, otherwise the connection fails with
+	// a transient error.
+	XClient *XClient
+}
+
+// XClient holds the attributes of the original SMTP client for the XCLIENT
+// command.
+type XClient struct {
+	Addr net.IP // Remote IP of original connection.
+	Helo string // EHLO/HELO hostname of original connection, can be an IP address literal.
 }
 
 // New initializes an SMTP session on the given connection, returning a client that
@@ -299,10 +317,13 @@ func New(ctx context.Context, elog *slog.Logger, conn net.Conn, tlsMode TLSMode,
 		config := c.tlsConfig()
 		tlsconn := tls.Client(conn, config)
 		// The tlsrpt tracking isn't used by caller, but won't hurt.
+		tlsStart := time.Now()
 		if err := tlsconn.HandshakeContext(ctx); err != nil {
+			tracing.Record(ctx, "tls.handshake", tracing.KindClient, tlsStart, err, tracing.String("tls.servername", remoteHostname.ASCII))
 			c.tlsResultAdd(0, 1, err)
 			return nil, err
 		}
+		tracing.Record(ctx, "tls.handshake", tracing.KindClient, tlsStart, nil, tlsAttrs(tlsconn, tracing.String("tls.servername", remoteHostname.ASCII))...)
 		c.firstReadAfterHandshake = true
 		c.tlsResultAdd(1, 0, nil)
 		c.conn = tlsconn
@@ -326,7 +347,7 @@ func New(ctx context.Context, elog *slog.Logger, conn net.Conn, tlsMode TLSMode,
 	c.tw = moxio.NewTraceWriter(c.log, "LC: ", timeoutWriter{c.conn, 30 * time.Second, c.log})
 	c.w = bufio.NewWriter(c.tw)
 
-	if err := c.hello(ctx, tlsMode, ehloHostname, opts.Auth); err != nil {
+	if err := c.hello(ctx, tlsMode, ehloHostname, opts.XClient, opts.Auth); err != nil {
 		return nil, err
 	}
 	return c, nil
@@ -344,6 +365,12 @@ func (e reportedError) Unwrap() error {
 	return e.err
 }
 
+// tlsAttrs returns span attributes for an established TLS connection.
+func tlsAttrs(conn *tls.Conn, attrs ...tracing.Attr) []tracing.Attr {
+	tlsversion, ciphersuite := moxio.TLSInfo(conn)
+	return append(attrs, tracing.String("tls.version", tlsversion), tracing.String("tls.cipher", ciphersuite))
+}
+
 func (c *Client) tlsConfig() *tls.Config {
 	// We always manage verification ourselves: We need to report in detail about
 	// failures. And we may have to verify both PKIX and DANE, record errors for
@@ -692,7 +719,7 @@ func (c *Client) recover(rerr *error) {
 	*rerr = cerr
 }
 
-func (c *Client) hello(ctx context.Context, tlsMode TLSMode, ehloHostname dns.Domain, auth func(mechanisms []string, cs *tls.ConnectionState) (sasl.Client, error)) (rerr error) {
+func (c *Client) hello(ctx context.Context, tlsMode TLSMode, ehloHostname dns.Domain, xclient *XClient, auth func(mechanisms []string, cs *tls.ConnectionState) (sasl.Client, error)) (rerr error) {
 	defer c.recover(&rerr)
 
 	// perform EHLO handshake, falling back to HELO if server does not appear to
@@ -753,6 +780,9 @@ func (c *Client) hello(ctx context.Context, tlsMode TLSMode, ehloHostname dns.Do
 					c.extAuthMechanisms = strings.Split(s[len("AUTH "):], " ")
 				} else if strings.HasPrefix(s, "LIMITS ") {
 					c.ExtLimits, c.ExtLimitMailMax, c.ExtLimitRcptMax, c.ExtLimitRcptDomainMax = parseLimits([]byte(s[len("LIMITS"):]))
+				} else if strings.HasPrefix(s, "XCLIENT ") {
+					attrs := strings.Split(s[len("XCLIENT "):], " ")
+					c.extXClient = slices.Contains(attrs, "ADDR") && slices.Contains(attrs, "HELO")
 				}
 			}
 		}
@@ -802,7 +832,13 @@ func (c *Client) hello(ctx context.Context, tlsMode TLSMode, ehloHostname dns.Do
 
 		nctx, cancel := context.WithTimeout(ctx, time.Minute)
 		defer cancel()
+		tlsStart := time.Now()
 		err := nconn.HandshakeContext(nctx)
+		attrs := []tracing.Attr{tracing.String("tls.servername", c.remoteHostname.ASCII), tracing.Bool("tls.starttls", true), tracing.Bool("tls.verifydane", c.daneRecords != nil), tracing.Bool("tls.verifypkix", c.tlsVerifyPKIX)}
+		if err == nil {
+			attrs = tlsAttrs(nconn, attrs...)
+		}
+		tracing.Record(ctx, "tls.handshake", tracing.KindClient, tlsStart, err, attrs...)
 		if err != nil {
 			// For each STARTTLS failure, we track a failed TLS session. For deliveries with
 			// multiple MX targets, we may add multiple failures, and delivery may succeed with
@@ -837,12 +873,45 @@ func (c *Client) hello(ctx context.Context, tlsMode TLSMode, ehloHostname dns.Do
 		c.tlsResultAddFailureDetails(0, 0, c.tlsrptFailureDetails(tlsrpt.ResultSTARTTLSNotSupported, ""))
 	}
 
+	if xclient != nil {
+		if !c.extXClient {
+			c.xerrorf(false, 0, "", "", nil, "%w: remote does not support xclient with addr and helo", ErrProtocol)
+		}
+		addr := xclient.Addr.String()
+		if xclient.Addr.To4() == nil {
+			addr = "IPV6:" + addr
+		}
+		c.cmds[0] = "xclient"
+		c.cmdStart = time.Now()
+		c.xwritelinef("XCLIENT ADDR=%s HELO=%s", xtext(addr), xtext(xclient.Helo))
+		code, secode, firstLine, moreLines := c.xread()
+		if code != smtp.C220ServiceReady {
+			c.xerrorf(code/100 == 5, code, secode, firstLine, moreLines, "%w: XCLIENT: got %d, expected 220", ErrStatus, code)
+		}
+		// The session is reset, and requires a new EHLO. XCLIENT is specified by Postfix,
+		// https://www.postfix.org/XCLIENT_README.html.
+		hello(false)
+	}
+
 	if auth != nil {
 		return c.auth(auth)
 	}
 	return
 }
 
+// xtext encodes s for use as value of an XCLIENT attribute.
+func xtext(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if c < '!' || c > '~' || c == '+' || c == '=' {
+			fmt.Fprintf(&b, "+%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
 // parse text after "LIMITS", including leading space.
 func parseLimits(b []byte) (map[string]string, int, int, int) {
 	// ../rfc/9422:150
@@ -1121,6 +1190,16 @@ var errNoRecipients = errors.New("no recipients accepted in transaction")
 // delivery attempt as failed. Also code "552" must be treated like temporary error
 // code "452" for historic reasons.
 func (c *Client) DeliverMultiple(ctx context.Context, mailFrom string, rcptTo []string, msgSize int64, msg io.Reader, req8bitmime, reqSMTPUTF8, requireTLS bool) (rcptResps []Response, rerr error) {
+	_, span := tracing.StartKind(ctx, "smtp.deliver", tracing.KindClient,
+		tracing.String("net.peer.name", c.remoteHostname.ASCII),
+		tracing.Int("smtp.recipients", len(rcptTo)),
+		tracing.Int64("smtp.size", msgSize),
+		tracing.Bool("tls", c.tls),
+	)
+	defer func() {
+		span.SetError(rerr)
+		span.End()
+	}()
 	defer c.recover(&rerr)
 
 	if len(rcptTo) == 0 {
@@ -7,6 +7,7 @@ import (
 	cryptorand "crypto/rand"
 	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/sha512"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/base64"
@@ -205,7 +206,7 @@ func TestClient(t *testing.T) {
 					writeline("334 " + base64.StdEncoding.EncodeToString([]byte("<123.1234@host>")))
 					readline("") // Proof
 					writeline("235 2.7.0 auth ok")
-				case "SCRAM-SHA-256-PLUS", "SCRAM-SHA-256", "SCRAM-SHA-1-PLUS", "SCRAM-SHA-1":
+				case "SCRAM-SHA-512-PLUS", "SCRAM-SHA-512", "SCRAM-SHA-256-PLUS", "SCRAM-SHA-256", "SCRAM-SHA-1-PLUS", "SCRAM-SHA-1":
 					// Cannot fake/hardcode scram interactions.
 					var h func() hash.Hash
 					salt := scram.MakeRandom()
@@ -217,6 +218,9 @@ func TestClient(t *testing.T) {
 					case "SCRAM-SHA-256-PLUS", "SCRAM-SHA-256":
 						h = sha256.New
 						iterations = 4096
+					case "SCRAM-SHA-512-PLUS", "SCRAM-SHA-512":
+						h = sha512.New
+						iterations = 10000
 					default:
 						panic("missing case for scram")
 					}
@@ -491,6 +495,16 @@ test
 	}
 	test(msg, options{ehlo: true, starttls: true, auths: []string{"SCRAM-SHA-256-PLUS"}}, authSCRAMSHA256PLUS, nil, nil, nil)
 
+	authSCRAMSHA512 := func(l []string, cs *tls.ConnectionState) (sasl.Client, error) {
+		return sasl.NewClientSCRAMSHA512("test", "test", false), nil
+	}
+	test(msg, options{ehlo: true, auths: []string{"SCRAM-SHA-512"}}, authSCRAMSHA512, nil, nil, nil)
+
+	authSCRAMSHA512PLUS := func(l []string, cs *tls.ConnectionState) (sasl.Client, error) {
+		return sasl.NewClientSCRAMSHA512PLUS("test", "test", *cs), nil
+	}
+	test(msg, options{ehlo: true, starttls: true, auths: []string{"SCRAM-SHA-512-PLUS"}}, authSCRAMSHA512PLUS, nil, nil, nil)
+
 	test(msg, options{ehlo: true, requiretls: false, needsrequiretls: true, nodeliver: true}, nil, nil, ErrRequireTLSUnsupported, nil)
 
 	// Set an expired certificate. For non-strict TLS, we should still accept it.
@@ -890,6 +904,73 @@ func run(t *testing.T, server func(s xserver), client func(conn net.Conn)) {
 	}
 }
 
+func TestXClient(t *testing.T) {
+	ctx := context.Background()
+	log := mlog.New("smtpclient", nil)
+
+	xclient := &XClient{Addr: net.ParseIP("2001:db8::1"), Helo: "remote+1.example"}
+
+	run(t, func(s xserver) {
+		s.writeline("220 mox.example")
+		s.readline("EHLO")
+		s.writeline("250-mox.example")
+		s.writeline("250 XCLIENT NAME ADDR HELO")
+		s.readline("XCLIENT ADDR=IPV6:2001:db8::1 HELO=remote+2B1.example")
+		s.writeline("220 mox.example")
+		s.readline("EHLO")
+		s.writeline("250 mox.example")
+		s.readline("MAIL FROM:")
+		s.writeline("250 ok")
+		s.readline("RCPT TO:")
+		s.writeline("250 ok")
+		s.readline("DATA")
+		s.writeline("354 continue")
+		s.readline("")
+		s.readline(".")
+		s.writeline("250 ok")
+	}, func(conn net.Conn) {
+		c, err := New(ctx, log.Logger, conn, TLSSkip, false, localhost, zerohost, Opts{XClient: xclient})
+		if err != nil {
+			panic(err)
+		}
+		msg := "\r\n"
+		err = c.Deliver(ctx, "postmaster@other.example", "mjl@mox.example", int64(len(msg)), strings.NewReader(msg), false, false, false)
+		if err != nil {
+			panic(err)
+		}
+	})
+
+	// Remote does not support XCLIENT.
+	run(t, func(s xserver) {
+		s.writeline("220 mox.example")
+		s.readline("EHLO")
+		s.writeline("250-mox.example")
+		s.writeline("250 XCLIENT NAME")
+	}, func(conn net.Conn) {
+		_, err := New(ctx, log.Logger, conn, TLSSkip, false, localhost, zerohost, Opts{XClient: xclient})
+		var xerr Error
+		if err == nil || !errors.Is(err, ErrProtocol) || !errors.As(err, &xerr) || xerr.Permanent {
+			panic(fmt.Errorf("got %#v, expected ErrProtocol without Permanent", err))
+		}
+	})
+
+	// Remote refuses XCLIENT.
+	run(t, func(s xserver) {
+		s.writeline("220 mox.example")
+		s.readline("EHLO")
+		s.writeline("250-mox.example")
+		s.writeline("250 XCLIENT ADDR HELO")
+		s.readline("XCLIENT")
+		s.writeline("550 not allowed")
+	}, func(conn net.Conn) {
+		_, err := New(ctx, log.Logger, conn, TLSSkip, false, localhost, zerohost, Opts{XClient: xclient})
+		var xerr Error
+		if err == nil || !errors.Is(err, ErrStatus) || !errors.As(err, &xerr) || !xerr.Permanent {
+			panic(fmt.Errorf("got %#v, expected ErrStatus with Permanent", err))
+		}
+	})
+}
+
 func TestLimits(t *testing.T) {
 	check := func(s string, expLimits map[string]string, expMailMax, expRcptMax, expRcptDomainMax int) {
 		t.Helper()
@@ -9,6 +9,7 @@ import (
 
 	"github.com/mjl-/mox/dns"
 	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/tracing"
 )
 
 // DialHook can be used during tests to override the regular dialer from being used.
@@ -72,7 +73,9 @@ func Dial(ctx context.Context, elog *slog.Logger, dialer Dialer, host dns.IPDoma
 				break
 			}
 		}
+		dialStart := time.Now()
 		conn, err := dial(ctx, dialer, timeout, addr, laddr)
+		tracing.Record(ctx, "smtp.dial", tracing.KindClient, dialStart, err, tracing.String("net.peer.name", host.String()), tracing.String("net.peer.addr", addr))
 		if err == nil {
 			log.Debug("connected to host",
 				slog.Any("host", host),
@@ -0,0 +1,142 @@
+package smtpserver
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/mox/admindb"
+	"github.com/mjl-/mox/arf"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/smtp"
+)
+
+var metricAbuseReport = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "mox_smtpserver_abuse_report_total",
+		Help: "Incoming abuse reports (ARF) at role addresses, by feedback type.",
+	},
+	[]string{"type"}, // abuse, fraud, virus, other, not-spam, auth-failure.
+)
+
+// abuseReportProcess correlates an abuse report received at role address rcpt
+// with the messages we sent through the delivery history, adds the recipients of
+// spam complaints to the suppression list of the sending account, and stores the
+// report for the admin.
+func abuseReportProcess(ctx context.Context, log mlog.Log, rcpt string, reporter smtp.Address, r *arf.Report) {
+	typ := r.FeedbackType
+	switch typ {
+	case arf.FeedbackAbuse, arf.FeedbackFraud, arf.FeedbackVirus, arf.FeedbackNotSpam, arf.FeedbackAuthFailure:
+	default:
+		typ = arf.FeedbackOther
+	}
+	metricAbuseReport.WithLabelValues(string(typ)).Inc()
+
+	ar := admindb.AbuseReport{
+		Rcpt:         rcpt,
+		ReporterFrom: reporter.String(),
+		FeedbackType: string(r.FeedbackType),
+		UserAgent:    r.UserAgent,
+		SourceIP:     r.SourceIP,
+		ArrivalDate:  r.ArrivalDate,
+		Incidents:    r.Incidents,
+		MessageID:    r.OriginalMessageID,
+		MailFrom:     r.OriginalMailFrom,
+		RcptTo:       strings.Join(r.OriginalRcptTo, ", "),
+		From:         r.OriginalFrom,
+		Subject:      r.OriginalSubject,
+	}
+
+	// Recipients named in the report. Reporters commonly redact them.
+	var reported []string
+	for _, s := range r.OriginalRcptTo {
+		if addr, err := smtp.ParseAddress(s); err == nil {
+			reported = append(reported, addr.Path().XString(true))
+		}
+	}
+
+	// Find the messages we queued with the Message-ID, and their recipients. If the
+	// report names no recipients, we only use the recipient of the matching messages
+	// if there is just one.
+	var accounts, recipients []string
+	if r.OriginalMessageID != "" {
+		events, err := admindb.MessageEventList(ctx, r.OriginalMessageID)
+		if err != nil {
+			log.Errorx("looking up delivery history for abuse report", err)
+		}
+		var queued []admindb.MessageEvent
+		for _, e := range events {
+			if e.Kind == admindb.EventQueued && e.QueueID != 0 && e.Account != "" {
+				queued = append(queued, e)
+			}
+		}
+		var eventRcpts []string
+		for _, e := range queued {
+			if !slices.Contains(eventRcpts, e.Recipient) {
+				eventRcpts = append(eventRcpts, e.Recipient)
+			}
+		}
+		for _, e := range queued {
+			if len(reported) > 0 && !slices.ContainsFunc(reported, func(s string) bool { return strings.EqualFold(s, e.Recipient) }) || len(reported) == 0 && len(eventRcpts) != 1 {
+				continue
+			}
+			ar.QueueIDs = append(ar.QueueIDs, e.QueueID)
+			if !slices.Contains(accounts, e.Account) {
+				accounts = append(accounts, e.Account)
+			}
+			if !slices.Contains(recipients, e.Recipient) {
+				recipients = append(recipients, e.Recipient)
+			}
+		}
+		// Still link the report to the sending account if the recipient is unknown.
+		if len(accounts) == 0 {
+			for _, e := range queued {
+				if !slices.Contains(accounts, e.Account) {
+					accounts = append(accounts, e.Account)
+				}
+			}
+		}
+	}
+	if len(accounts) == 1 {
+		ar.Account = accounts[0]
+	}
+	if len(recipients) > 0 {
+		ar.Recipients = recipients
+	} else {
+		ar.Recipients = reported
+	}
+
+	// Spam complaints mean the recipient does not want our messages. Keep sending
+	// and mailbox providers will lower the reputation of our IPs and domains.
+	if r.FeedbackType == arf.FeedbackAbuse && ar.Account != "" && len(recipients) > 0 {
+		var paths []smtp.Path
+		for _, s := range recipients {
+			if addr, err := smtp.ParseAddress(s); err == nil {
+				paths = append(paths, addr.Path())
+			}
+		}
+		reason := fmt.Sprintf("spam complaint in abuse report from %s", reporter.Domain.Name())
+		added, err := queue.SuppressionComplaint(ctx, log, ar.Account, paths, reason)
+		if err != nil {
+			log.Errorx("adding recipients of spam complaint to suppression list", err)
+		}
+		ar.Suppressed = added
+	}
+
+	log.Info("abuse report processed",
+		slog.String("rcpt", rcpt),
+		slog.String("reporter", ar.ReporterFrom),
+		slog.String("feedbacktype", ar.FeedbackType),
+		slog.String("messageid", ar.MessageID),
+		slog.String("account", ar.Account),
+		slog.Any("suppressed", ar.Suppressed))
+	if err := admindb.AbuseReportAdd(ctx, &ar); err != nil {
+		log.Errorx("storing abuse report", err)
+	}
+}
@@ -1,13 +1,17 @@
 package smtpserver
 
 import (
+	"errors"
 	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/mjl-/bstore"
 
+	"github.com/mjl-/mox/admindb"
 	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/moderation"
+	"github.com/mjl-/mox/queue"
 	"github.com/mjl-/mox/smtp"
 	"github.com/mjl-/mox/smtpclient"
 	"github.com/mjl-/mox/store"
@@ -290,3 +294,83 @@ test email
 		ts.smtpErr(err, &smtpclient.Error{Code: smtp.C451LocalErr, Secode: smtp.SeSys3Other0})
 	})
 }
+
+// Messages to alias are queued for external members. Messages from non-members to
+// moderated alias are held until approved.
+func TestAliasExternalModerated(t *testing.T) {
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"mox.example.": {"127.0.0.10"}, // For mx check.
+			"example.org.": {"127.0.0.10"}, // For mx check.
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"mox.example."}, // To get passed junk filter.
+		},
+		TXT: map[string][]string{
+			"mox.example.": {"v=spf1 ip4:127.0.0.10 -all"},
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), resolver)
+	defer ts.close()
+	err := admindb.Init()
+	tcheck(t, err, "admindb init")
+	defer admindb.Close()
+
+	// From local member, only queued for external member.
+	var msg = strings.ReplaceAll(`From: <mjl@mox.example>
+To: <team@mox.example>
+Subject: test
+
+test email
+`, "\n", "\r\n")
+
+	ts.run(func(err error, client *smtpclient.Client) {
+		t.Helper()
+		if err == nil {
+			err = client.Deliver(ctxbg, "mjl@mox.example", "team@mox.example", int64(len(msg)), strings.NewReader(msg), false, false, false)
+		}
+		ts.smtpErr(err, nil)
+		ts.checkCount("Inbox", 0)
+	})
+
+	ql, err := queue.List(ctxbg, queue.Filter{}, queue.Sort{})
+	tcheck(t, err, "list queue")
+	if len(ql) != 1 || ql[0].Recipient().String() != "ext@remote.example" || ql[0].Sender().String() != "mjl@mox.example" || ql[0].SenderAccount != "mjl" {
+		t.Fatalf("got queue %v, expected message from owner to external member", ql)
+	}
+
+	// From non-member, held for moderation. Owner is notified.
+	msg = strings.ReplaceAll(`From: <other@example.org>
+To: <team@mox.example>
+Subject: test
+
+test email
+`, "\n", "\r\n")
+
+	ts.run(func(err error, client *smtpclient.Client) {
+		t.Helper()
+		if err == nil {
+			err = client.Deliver(ctxbg, "other@example.org", "team@mox.example", int64(len(msg)), strings.NewReader(msg), false, false, false)
+		}
+		ts.smtpErr(err, nil)
+		ts.checkCount("Inbox", 1)
+	})
+
+	hl, err := bstore.QueryDB[admindb.ModerationHeld](ctxbg, admindb.DB).List()
+	tcheck(t, err, "list held messages")
+	if len(hl) != 1 || hl[0].Alias != "team@mox.example" || hl[0].MsgFrom != "other@example.org" {
+		t.Fatalf("got held messages %v, expected one", hl)
+	}
+
+	err = moderation.Approve(ctxbg, pkglog, hl[0].Token)
+	tcheck(t, err, "approve")
+	ts.checkCount("Inbox", 2)
+	n, err := queue.Count(ctxbg)
+	tcheck(t, err, "count queue")
+	tcompare(t, n, 2)
+
+	err = moderation.Approve(ctxbg, pkglog, hl[0].Token)
+	if !errors.Is(err, admindb.ErrNotFound) {
+		t.Fatalf("approving again, got err %v, expected not found", err)
+	}
+}
@@ -3,15 +3,20 @@ package smtpserver
 import (
 	"context"
 	"fmt"
+	"io"
 	"log/slog"
 	"net"
 	"os"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/mjl-/bstore"
 
+	"github.com/mjl-/mox/admindb"
+	"github.com/mjl-/mox/arf"
 	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/contentbl"
 	"github.com/mjl-/mox/dkim"
 	"github.com/mjl-/mox/dmarc"
 	"github.com/mjl-/mox/dmarcrpt"
@@ -23,9 +28,11 @@ import (
 	"github.com/mjl-/mox/mox-"
 	"github.com/mjl-/mox/publicsuffix"
 	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/spamscan"
 	"github.com/mjl-/mox/store"
 	"github.com/mjl-/mox/subjectpass"
 	"github.com/mjl-/mox/tlsrpt"
+	"github.com/mjl-/mox/tracing"
 )
 
 type delivery struct {
@@ -45,6 +52,21 @@ type delivery struct {
 	dmarcResult      dmarc.Result
 	dkimResults      []dkim.Result
 	iprevStatus      iprev.Status
+	contentCheck     func() contentbl.Result // Checks against content block lists, once per message.
+	messageID        string                  // Message-ID header, for the delivery history.
+}
+
+// event records a step in the delivery history of the message for the recipient.
+func (d delivery) event(ctx context.Context, log mlog.Log, kind admindb.EventKind, result, detail string) {
+	admindb.MessageEventAdd(ctx, log, admindb.MessageEvent{
+		MessageID: d.messageID,
+		Kind:      kind,
+		Account:   d.acc.Name,
+		Recipient: d.deliverTo.String(),
+		Remote:    d.m.RemoteIP,
+		Result:    result,
+		Detail:    detail,
+	})
 }
 
 type analysis struct {
@@ -58,6 +80,7 @@ type analysis struct {
 	err                 error              // For our own logging, not sent to remote.
 	dmarcReport         *dmarcrpt.Feedback // Validated DMARC aggregate report, not yet stored.
 	tlsReport           *tlsrpt.Report     // Validated TLS report, not yet stored.
+	abuseReport         *arf.Report        // Abuse report for role address from validated sender, not yet processed.
 	reason              string             // If non-empty, reason for this decision. Can be one of reputationMethod and a few other tokens.
 	dmarcOverrideReason string             // If set, one of dmarcrpt.PolicyOverride
 	// Additional headers to add during delivery. Used for reasons a message to a
@@ -82,6 +105,16 @@ const (
 	reasonSubjectpassError  = "subjectpass-error"
 	reasonIPrev             = "iprev"     // No or mild junk reputation signals, and bad iprev.
 	reasonHighRate          = "high-rate" // Too many messages, not added to rejects.
+	reasonSpamScan          = "spamscan"  // External spam scanner, for account without junk filter.
+	reasonSpamScanError     = "spamscan-error"
+	reasonURIBlocklisted    = "uri-blocklisted"
+	reasonHashBlocklisted   = "hash-blocklisted"
+	reasonSenderAllow       = "sender-allow"
+	reasonSenderReject      = "sender-reject"
+	reasonSenderJunk        = "sender-junk"
+	reasonDNSGreylisted     = "dns-greylisted" // Temporary, not added to rejects.
+	reasonDNSTagged         = "dns-tagged"
+	reasonSpamtrap          = "spamtrap" // Remote network or sender domain recently sent to a spamtrap.
 )
 
 func isListDomain(d delivery, ld dns.Domain) bool {
@@ -96,6 +129,125 @@ func isListDomain(d delivery, ld dns.Domain) bool {
 	return false
 }
 
+// findJunkMailbox returns the name of the mailbox with the junk special-use flag,
+// or "Junk" if there is none.
+func findJunkMailbox(ctx context.Context, acc *store.Account) (string, error) {
+	junkMailbox := "Junk"
+	err := acc.DB.Read(ctx, func(tx *bstore.Tx) error {
+		mb, err := bstore.QueryTx[store.Mailbox](tx).FilterFn(func(mb store.Mailbox) bool { return mb.Junk }).Limit(1).Get()
+		if err == nil {
+			junkMailbox = mb.Name
+		} else if err != bstore.ErrAbsent {
+			return err
+		}
+		return nil
+	})
+	return junkMailbox, err
+}
+
+// senderListAction evaluates the allow/deny sender lists of the account and of
+// the recipient domain for the message From and SMTP MAIL FROM addresses. Entries
+// of the account take precedence over those of the domain. Deny actions for either
+// address take precedence over allow actions. Allow actions only apply to
+// validated addresses: the message From address with a DMARC pass, or the MAIL
+// FROM address with an SPF pass.
+func senderListAction(ctx context.Context, d delivery) (action store.SenderAction, sender string, rerr error) {
+	var domainActions map[string]string
+	if dom, ok := mox.Conf.Domain(d.deliverTo.IPDomain.Domain); ok {
+		domainActions = dom.SenderActions
+	}
+	var mailFrom smtp.Address
+	if d.m.Sealed.MailFrom != "" {
+		mailFrom, _ = smtp.ParseAddress(d.m.Sealed.MailFrom)
+	}
+	candidates := []struct {
+		addr      smtp.Address
+		validated bool
+	}{
+		{d.msgFrom, d.dmarcResult.Status == dmarc.StatusPass},
+		{mailFrom, d.m.MailFromValidated},
+	}
+
+	var allow string
+	err := d.acc.DB.Read(ctx, func(tx *bstore.Tx) error {
+		for _, c := range candidates {
+			if c.addr.IsZero() {
+				continue
+			}
+			a, s, err := d.acc.SenderListMatch(tx, c.addr)
+			if err != nil {
+				return err
+			}
+			if a == "" {
+				for _, k := range mox.SenderKeys(c.addr) {
+					if v, ok := domainActions[k]; ok {
+						a, s = store.SenderAction(v), k
+						break
+					}
+				}
+			}
+			switch {
+			case a == store.SenderReject, a == store.SenderJunk && action != store.SenderReject:
+				action, sender = a, s
+			case a == store.SenderAllow && c.validated && allow == "":
+				allow = s
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", "", err
+	}
+	if action == "" && allow != "" {
+		action, sender = store.SenderAllow, allow
+	}
+	return action, sender, nil
+}
+
+// knownSender returns whether a validated sender of the message, the message From
+// address with a DMARC pass or the MAIL FROM address with an SPF pass, is a known
+// correspondent of the account, see store.Account.KnownSender. Also returned is
+// whether the message is from a first-time sender: not known, and no earlier
+// message from the message From address was delivered to the account. Messages
+// without validated From address are always from first-time senders.
+func knownSender(ctx context.Context, d delivery) (known, firstTime bool, rerr error) {
+	var mailFrom smtp.Address
+	if d.m.Sealed.MailFrom != "" {
+		mailFrom, _ = smtp.ParseAddress(d.m.Sealed.MailFrom)
+	}
+	fromValidated := d.dmarcResult.Status == dmarc.StatusPass && !d.msgFrom.IsZero()
+	var candidates []smtp.Address
+	if fromValidated {
+		candidates = append(candidates, d.msgFrom)
+	}
+	if d.m.MailFromValidated && !mailFrom.IsZero() {
+		candidates = append(candidates, mailFrom)
+	}
+
+	err := d.acc.DB.Read(ctx, func(tx *bstore.Tx) error {
+		for _, addr := range candidates {
+			ok, err := d.acc.KnownSender(tx, addr)
+			if err != nil {
+				return err
+			} else if ok {
+				known = true
+				return nil
+			}
+		}
+		if !fromValidated {
+			firstTime = true
+			return nil
+		}
+		q := bstore.QueryTx[store.Message](tx)
+		q.FilterNonzero(store.Message{MsgFromAddressKey: store.AddressKey(d.acc.Name, d.msgFrom.Localpart, d.msgFrom.Domain.Name())})
+		q.FilterEqual("Expunged", false)
+		exists, err := q.Exists()
+		firstTime = !exists
+		return err
+	})
+	return known, firstTime, err
+}
+
 func analyze(ctx context.Context, log mlog.Log, resolver dns.Resolver, d delivery) analysis {
 	var headers string
 
@@ -175,11 +327,11 @@ func analyze(ctx context.Context, log mlog.Log, resolver dns.Resolver, d deliver
 	if err != nil && !rateError {
 		log.Errorx("checking delivery rates", err)
 		metricDelivery.WithLabelValues("checkrates", "").Inc()
-		return analysis{d, false, "", smtp.C451LocalErr, smtp.SeSys3Other0, false, "error processing", err, nil, nil, reasonReputationError, "", headers}
+		return analysis{d, false, "", smtp.C451LocalErr, smtp.SeSys3Other0, false, "error processing", err, nil, nil, nil, reasonReputationError, "", headers}
 	} else if err != nil {
 		log.Debugx("refusing due to high delivery rate", err)
 		metricDelivery.WithLabelValues("highrate", "").Inc()
-		return analysis{d, false, "", smtp.C452StorageFull, smtp.SeMailbox2Full2, true, err.Error(), err, nil, nil, reasonHighRate, "", headers}
+		return analysis{d, false, "", smtp.C452StorageFull, smtp.SeMailbox2Full2, true, err.Error(), err, nil, nil, nil, reasonHighRate, "", headers}
 	}
 
 	mailbox := d.destination.Mailbox
@@ -189,9 +341,14 @@ func analyze(ctx context.Context, log mlog.Log, resolver dns.Resolver, d deliver
 
 	// If destination mailbox has a mailing list domain (for SPF/DKIM) configured,
 	// check it for a pass.
-	rs := store.MessageRuleset(log, d.destination, d.m, d.m.MsgPrefix, d.dataFile)
+	rs := store.MessageRuleset(log, d.destination, d.m, d.dataFile)
 	if rs != nil {
 		mailbox = rs.Mailbox
+		detail := "mailbox " + rs.Mailbox
+		if rs.Comment != "" {
+			detail += ", " + rs.Comment
+		}
+		d.event(ctx, log, admindb.EventRuleset, "", detail)
 	}
 	if rs != nil && !rs.ListAllowDNSDomain.IsZero() {
 		// todo: on temporary failures, reject temporarily?
@@ -266,7 +423,7 @@ func analyze(ctx context.Context, log mlog.Log, resolver dns.Resolver, d deliver
 				})
 			})
 			if mberr != nil {
-				return analysis{d, false, mailbox, smtp.C451LocalErr, smtp.SeSys3Other0, false, "error processing", err, nil, nil, reasonReputationError, dmarcOverrideReason, headers}
+				return analysis{d, false, mailbox, smtp.C451LocalErr, smtp.SeSys3Other0, false, "error processing", err, nil, nil, nil, reasonReputationError, dmarcOverrideReason, headers}
 			}
 			d.m.MailboxID = 0 // We plan to reject, no need to set intended MailboxID.
 		}
@@ -280,7 +437,7 @@ func analyze(ctx context.Context, log mlog.Log, resolver dns.Resolver, d deliver
 			d.m.Seen = true
 			log.Info("accepting reject to configured mailbox due to ruleset")
 		}
-		return analysis{d, accept, mailbox, code, secode, err == nil, errmsg, err, nil, nil, reason, dmarcOverrideReason, headers}
+		return analysis{d, accept, mailbox, code, secode, err == nil, errmsg, err, nil, nil, nil, reason, dmarcOverrideReason, headers}
 	}
 
 	if d.dmarcUse && d.dmarcResult.Reject {
@@ -296,7 +453,7 @@ func analyze(ctx context.Context, log mlog.Log, resolver dns.Resolver, d deliver
 		if d.dmarcResult.Status != dmarc.StatusPass {
 			log.Info("received dmarc aggregate report without dmarc pass, not processing as dmarc report")
 			headers += "X-Mox-DMARCReport-Error: no DMARC pass\r\n"
-		} else if report, err := dmarcrpt.ParseMessageReport(log.Logger, store.FileMsgReader(d.m.MsgPrefix, d.dataFile)); err != nil {
+		} else if report, err := dmarcrpt.ParseMessageReport(log.Logger, store.FileMsgReader(d.m.Sealed.MsgPrefix, d.dataFile)); err != nil {
 			log.Infox("parsing dmarc aggregate report", err)
 			headers += "X-Mox-DMARCReport-Error: could not parse report\r\n"
 		} else if d, err := dns.ParseDomain(report.PolicyPublished.Domain); err != nil {
@@ -345,7 +502,7 @@ func analyze(ctx context.Context, log mlog.Log, resolver dns.Resolver, d deliver
 		if !ok {
 			log.Info("received mail to tlsrpt without acceptable DKIM signature, not processing as tls report")
 			headers += "X-Mox-TLSReport-Error: no acceptable DKIM signature\r\n"
-		} else if reportJSON, err := tlsrpt.ParseMessage(log.Logger, store.FileMsgReader(d.m.MsgPrefix, d.dataFile)); err != nil {
+		} else if reportJSON, err := tlsrpt.ParseMessage(log.Logger, store.FileMsgReader(d.m.Sealed.MsgPrefix, d.dataFile)); err != nil {
 			log.Infox("parsing tls report", err)
 			headers += "X-Mox-TLSReport-Error: could not parse TLS report\r\n"
 		} else {
@@ -369,6 +526,75 @@ func analyze(ctx context.Context, log mlog.Log, resolver dns.Resolver, d deliver
 		}
 	}
 
+	// Messages to role addresses like abuse@ and postmaster@ can be abuse reports
+	// about messages we sent. We only process reports from senders with a validated
+	// From address, reports cause recipients to be added to the suppression list.
+	// Like other reports, we check reputation, defaulting to accept.
+	var abuseReport *arf.Report
+	if mox.IsRoleLocalpart(d.smtpRcptTo.Localpart) {
+		if report, err := arf.Parse(log.Logger, store.FileMsgReader(d.m.Sealed.MsgPrefix, d.dataFile)); err == arf.ErrNoReport {
+			// Regular message.
+		} else if err != nil {
+			log.Infox("parsing abuse report", err)
+			headers += "X-Mox-AbuseReport-Error: could not parse report\r\n"
+		} else if !d.m.MsgFromValidated {
+			log.Info("received abuse report without validated from address, not processing as abuse report")
+			headers += "X-Mox-AbuseReport-Error: no validated From address\r\n"
+		} else {
+			abuseReport = report
+		}
+	}
+
+	// Check content block lists. Also for senders with good reputation, their
+	// accounts may have been compromised to send malware.
+	var uriblocklisted bool
+	if cb := mox.Conf.Static.ContentBlocklists; cb != nil {
+		cr := d.contentCheck()
+		headers += cr.Header(mox.Conf.Static.HostnameDomain, len(cb.URIBLZones) > 0, len(cb.HashFiles) > 0)
+		if len(cr.HashListed) > 0 {
+			log.Info("rejecting due to attachment in hash list", slog.Any("hashlisted", cr.HashListed))
+			return reject(smtp.C550MailboxUnavail, smtp.SePol7Other0, "message has attachment listed as malicious", nil, reasonHashBlocklisted)
+		}
+		uriblocklisted = len(cr.URIListed) > 0
+	}
+
+	// Check the allow/deny sender lists of the account and the recipient domain.
+	action, sender, err := senderListAction(ctx, d)
+	if err != nil {
+		log.Errorx("checking sender lists", err)
+		return reject(smtp.C451LocalErr, smtp.SeSys3Other0, "error processing", err, reasonReputationError)
+	}
+	switch action {
+	case store.SenderReject:
+		log.Info("rejecting due to sender list", slog.String("sender", sender))
+		return reject(smtp.C550MailboxUnavail, smtp.SePol7DeliveryUnauth1, "sender not accepted by recipient", nil, reasonSenderReject)
+	case store.SenderJunk:
+		junkMailbox, err := findJunkMailbox(ctx, d.acc)
+		if err != nil {
+			log.Errorx("looking up junk mailbox", err)
+			return reject(smtp.C451LocalErr, smtp.SeSys3Other0, "error processing", err, reasonReputationError)
+		}
+		log.Info("delivering to junk mailbox due to sender list", slog.String("sender", sender), slog.String("mailbox", junkMailbox))
+		d.m.Junk = true
+		return analysis{d: d, accept: true, mailbox: junkMailbox, reason: reasonSenderJunk, dmarcOverrideReason: dmarcOverrideReason, headers: headers}
+	case store.SenderAllow:
+		log.Info("accepting due to sender list", slog.String("sender", sender))
+		return analysis{d: d, accept: true, mailbox: mailbox, dmarcReport: dmarcReport, tlsReport: tlsReport, abuseReport: abuseReport, reason: reasonSenderAllow, dmarcOverrideReason: dmarcOverrideReason, headers: headers}
+	}
+
+	// Known senders, i.e. contacts and recipients of sent messages, are not
+	// greylisted and get a more lenient junk threshold. Messages from first-time
+	// senders get a header, which webmail shows as a badge.
+	known, firstTime, err := knownSender(ctx, d)
+	if err != nil {
+		log.Errorx("checking known sender", err)
+		return reject(smtp.C451LocalErr, smtp.SeSys3Other0, "error processing", err, reasonReputationError)
+	}
+	log.Debug("known sender", slog.Bool("known", known), slog.Bool("firsttime", firstTime))
+	if firstTime {
+		headers += "X-Mox-First-Time-Sender: yes\r\n"
+	}
+
 	// Determine if message is acceptable based on DMARC domain, DKIM identities, or
 	// host-based reputation.
 	var isjunk *bool
@@ -396,12 +622,12 @@ func analyze(ctx context.Context, log mlog.Log, resolver dns.Resolver, d deliver
 		slog.String("method", string(method)))
 	if conclusive {
 		if !*isjunk {
-			return analysis{d: d, accept: true, mailbox: mailbox, dmarcReport: dmarcReport, tlsReport: tlsReport, reason: reason, dmarcOverrideReason: dmarcOverrideReason, headers: headers}
+			return analysis{d: d, accept: true, mailbox: mailbox, dmarcReport: dmarcReport, tlsReport: tlsReport, abuseReport: abuseReport, reason: reason, dmarcOverrideReason: dmarcOverrideReason, headers: headers}
 		}
 		return reject(smtp.C451LocalErr, smtp.SeSys3Other0, "error processing", err, string(method))
-	} else if dmarcReport != nil || tlsReport != nil {
-		log.Info("accepting message with dmarc aggregate report or tls report without reputation")
-		return analysis{d: d, accept: true, mailbox: mailbox, dmarcReport: dmarcReport, tlsReport: tlsReport, reason: reasonReporting, dmarcOverrideReason: dmarcOverrideReason, headers: headers}
+	} else if dmarcReport != nil || tlsReport != nil || abuseReport != nil {
+		log.Info("accepting message with dmarc aggregate report, tls report or abuse report without reputation")
+		return analysis{d: d, accept: true, mailbox: mailbox, dmarcReport: dmarcReport, tlsReport: tlsReport, abuseReport: abuseReport, reason: reasonReporting, dmarcOverrideReason: dmarcOverrideReason, headers: headers}
 	}
 	// If there was no previous message from sender or its domain, and we have an SPF
 	// (soft)fail, reject the message.
@@ -441,6 +667,15 @@ func analyze(ctx context.Context, log mlog.Log, resolver dns.Resolver, d deliver
 		}
 	}
 
+	scans, err := spamScan(ctx, log, d)
+	if err != nil {
+		log.Errorx("external spam scan", err)
+		return reject(smtp.C451LocalErr, smtp.SeSys3Other0, "error processing", err, reasonSpamScanError)
+	}
+	for _, r := range scans {
+		headers += fmt.Sprintf("X-Mox-Spamscan: %s; spam=%v; score=%.2f; required=%.2f\r\n", r.Scanner, r.Spam, r.Score, r.Required)
+	}
+
 	reason = reasonNoBadSignals
 	accept := true
 	var junkSubjectpass bool
@@ -450,7 +685,15 @@ func analyze(ctx context.Context, log mlog.Log, resolver dns.Resolver, d deliver
 			err := f.Close()
 			log.Check(err, "closing junkfilter")
 		}()
-		contentProb, _, _, _, err := f.ClassifyMessageReader(ctx, store.FileMsgReader(d.m.MsgPrefix, d.dataFile), d.m.Size)
+		classifyStart := time.Now()
+		contentProb, _, nham, nspam, err := f.ClassifyMessageReader(ctx, store.FileMsgReader(d.m.Sealed.MsgPrefix, d.dataFile), d.m.Size)
+		if err == nil && jf.SharedWeight > 0 {
+			contentProb, err = store.ClassifyShared(ctx, log, jf.SharedWeight, contentProb, nham+nspam, store.FileMsgReader(d.m.Sealed.MsgPrefix, d.dataFile), d.m.Size)
+		}
+		if err == nil && len(scans) > 0 {
+			contentProb = spamScanCombine(log, contentProb, scans, jf.Threshold)
+		}
+		tracing.Record(ctx, "junk.classify", tracing.KindInternal, classifyStart, err, tracing.Float64("junk.probability", contentProb), tracing.Int("junk.nham", nham), tracing.Int("junk.nspam", nspam))
 		if err != nil {
 			log.Errorx("testing for spam", err)
 			return reject(smtp.C451LocalErr, smtp.SeSys3Other0, "error processing", err, reasonJunkClassifyError)
@@ -487,7 +730,11 @@ func analyze(ctx context.Context, log mlog.Log, resolver dns.Resolver, d deliver
 		// todo: some of these checks should also apply for reputation-based analysis with a weak signal, e.g. verified dkim/spf signal from new domain.
 		// With an iprev fail, non-TLS connection or our address not in To/Cc header, we set a higher bar for content.
 		reason = reasonJunkContent
-		if suspiciousIPrevFail && threshold > 0.25 {
+		if known {
+			// Known senders get the benefit of the doubt, also over the signals below.
+			threshold += (1 - threshold) / 2
+			log.Info("raising junk threshold for known sender", slog.Float64("threshold", threshold))
+		} else if suspiciousIPrevFail && threshold > 0.25 {
 			threshold = 0.25
 			log.Info("setting junk threshold due to iprev fail", slog.Float64("threshold", threshold))
 			reason = reasonJunkContentStrict
@@ -507,6 +754,17 @@ func analyze(ctx context.Context, log mlog.Log, resolver dns.Resolver, d deliver
 		}
 		accept = contentProb <= threshold
 		junkSubjectpass = contentProb < threshold-0.2
+		result, thresholdKind := "ham", "normal"
+		if !accept {
+			result = "spam"
+		}
+		if reason == reasonJunkContentStrict {
+			thresholdKind = "strict"
+		} else if known {
+			thresholdKind = "known"
+		}
+		metricJunkClassification.WithLabelValues(result, thresholdKind).Observe(contentProb)
+		d.event(ctx, log, admindb.EventJunk, result, fmt.Sprintf("probability %.3f, %s threshold %.3f, %d ham and %d spam words", contentProb, thresholdKind, threshold, nham, nspam))
 		log.Info("content analyzed",
 			slog.Bool("accept", accept),
 			slog.Float64("contentprob", contentProb),
@@ -514,13 +772,78 @@ func analyze(ctx context.Context, log mlog.Log, resolver dns.Resolver, d deliver
 	} else if err != store.ErrNoJunkFilter {
 		log.Errorx("open junkfilter", err)
 		return reject(smtp.C451LocalErr, smtp.SeSys3Other0, "error processing", err, reasonJunkFilterError)
+	} else if slices.ContainsFunc(scans, func(r spamscan.Result) bool { return r.Spam }) {
+		// Without junk filter, the verdict of the external spam scanners decides.
+		log.Info("external spam scanner considers message spam")
+		accept = false
+		reason = reasonSpamScan
+	}
+
+	// URLs in URI block lists make us reject like IPs in DNS block lists.
+	if accept && uriblocklisted {
+		log.Info("rejecting due to url in uri block list")
+		accept = false
+		reason = reasonURIBlocklisted
+	}
+
+	// Senders that recently sent to our spamtraps are rejected, unless they have a
+	// good reputation with the account.
+	if accept {
+		domain := spamtrapSenderDomain(ctx, log, d.msgFrom.Domain.Name(), d.m.MsgFromValidated, d.m.MailFromDomain, d.m.MailFromValidated)
+		if listed, err := spamtrapListed(ctx, d.m.RemoteIPMasked2, domain); err != nil {
+			log.Errorx("checking spamtrap hits", err)
+		} else if listed {
+			log.Info("rejecting due to recent spamtrap hits", slog.String("network", d.m.RemoteIPMasked2), slog.String("domain", domain))
+			accept = false
+			reason = reasonSpamtrap
+		}
 	}
 
 	// If content looks good, we'll still look at DNS block lists for a reason to
 	// reject. We normally won't get here if we've communicated with this sender
 	// before.
 	var dnsblocklisted bool
-	if accept {
+	if conf := mox.Conf.Static.DNSBLScoring; accept && conf != nil {
+		// With scoring, the weights of all lists containing the IP are summed, and
+		// thresholds determine the action.
+		s := dnsblScore(ctx, log, resolver, conf, net.ParseIP(d.m.RemoteIP))
+		headers += s.header(mox.Conf.Static.HostnameDomain)
+		rejectScore := conf.RejectScore
+		if rejectScore == 0 {
+			rejectScore = 10
+		}
+		log.Info("dnsbl score", slog.Float64("score", s.score))
+		switch {
+		case s.score >= rejectScore:
+			log.Info("rejecting due to dnsbl score", slog.Float64("score", s.score), slog.Float64("threshold", rejectScore))
+			accept = false
+			dnsblocklisted = true
+			reason = reasonDNSBlocklisted
+		case conf.GreylistScore > 0 && s.score >= conf.GreylistScore && known:
+			log.Info("not greylisting known sender", slog.Float64("score", s.score), slog.Float64("threshold", conf.GreylistScore))
+		case conf.GreylistScore > 0 && s.score >= conf.GreylistScore:
+			delay := conf.GreylistDelay
+			if delay == 0 {
+				delay = 5 * time.Minute
+			}
+			key := greylistKey{d.m.RemoteIPMasked2, strings.ToLower(d.m.Sealed.MailFrom), d.smtpRcptTo.String()}
+			if !greylistPass(key, delay, time.Now()) {
+				log.Info("greylisting due to dnsbl score", slog.Float64("score", s.score), slog.Float64("threshold", conf.GreylistScore))
+				// Not through reject, we don't want greylisted messages in the rejects mailbox.
+				return analysis{d, false, mailbox, smtp.C451LocalErr, smtp.SePol7Other0, true, "greylisted, try again later", nil, nil, nil, nil, reasonDNSGreylisted, dmarcOverrideReason, headers}
+			}
+			log.Info("greylisted message retried, accepting", slog.Float64("score", s.score))
+		case conf.TagScore > 0 && s.score >= conf.TagScore:
+			junkMailbox, err := findJunkMailbox(ctx, d.acc)
+			if err != nil {
+				log.Errorx("looking up junk mailbox", err)
+				return reject(smtp.C451LocalErr, smtp.SeSys3Other0, "error processing", err, reasonReputationError)
+			}
+			log.Info("delivering to junk mailbox due to dnsbl score", slog.Float64("score", s.score), slog.Float64("threshold", conf.TagScore), slog.String("mailbox", junkMailbox))
+			d.m.Junk = true
+			return analysis{d: d, accept: true, mailbox: junkMailbox, reason: reasonDNSTagged, dmarcOverrideReason: dmarcOverrideReason, headers: headers}
+		}
+	} else if accept {
 		blocked := func(zone dns.Domain) bool {
 			dnsblctx, dnsblcancel := context.WithTimeout(ctx, 30*time.Second)
 			defer dnsblcancel()
@@ -555,7 +878,7 @@ func analyze(ctx context.Context, log mlog.Log, resolver dns.Resolver, d deliver
 		return analysis{d: d, accept: true, mailbox: mailbox, reason: reasonNoBadSignals, dmarcOverrideReason: dmarcOverrideReason, headers: headers}
 	}
 
-	if subjectpassKey != "" && d.dmarcResult.Status == dmarc.StatusPass && method == methodNone && (dnsblocklisted || junkSubjectpass) {
+	if subjectpassKey != "" && d.dmarcResult.Status == dmarc.StatusPass && method == methodNone && (dnsblocklisted || uriblocklisted || junkSubjectpass) {
 		log.Info("permanent reject with subjectpass hint of moderately spammy email without reputation")
 		pass := subjectpass.Generate(log.Logger, d.msgFrom, []byte(subjectpassKey), time.Now())
 		return reject(smtp.C550MailboxUnavail, smtp.SePol7DeliveryUnauth1, subjectpass.Explanation+pass, nil, reasonGiveSubjectpass)
@@ -563,3 +886,66 @@ func analyze(ctx context.Context, log mlog.Log, resolver dns.Resolver, d deliver
 
 	return reject(smtp.C451LocalErr, smtp.SeSys3Other0, "error processing", nil, reason)
 }
+
+// spamScan scores the message with the configured external spam scanners. If a
+// scanner fails and errors are not ignored, an error is returned.
+func spamScan(ctx context.Context, log mlog.Log, d delivery) ([]spamscan.Result, error) {
+	conf := mox.Conf.Static.SpamScan
+	if conf == nil {
+		return nil, nil
+	}
+	var scanners []spamscan.Scanner
+	if conf.Rspamd != nil {
+		scanners = append(scanners, spamscan.Rspamd{URL: conf.Rspamd.URL, Password: conf.Rspamd.Password})
+	}
+	if conf.Spamd != nil {
+		scanners = append(scanners, spamscan.Spamd{Address: conf.Spamd.Address, User: conf.Spamd.User})
+	}
+	timeout := conf.Timeout
+	if timeout == 0 {
+		timeout = 15 * time.Second
+	}
+	meta := spamscan.Meta{
+		RemoteIP: d.m.RemoteIP,
+		EHLO:     d.m.EHLODomain,
+		MailFrom: d.m.Sealed.MailFrom,
+		RcptTo:   d.deliverTo.XString(true),
+		User:     d.acc.Name,
+	}
+
+	var results []spamscan.Result
+	for _, s := range scanners {
+		scanctx, scancancel := context.WithTimeout(ctx, timeout)
+		msgr := io.NewSectionReader(store.FileMsgReader(d.m.Sealed.MsgPrefix, d.dataFile), 0, d.m.Size)
+		r, err := s.Scan(scanctx, log, meta, msgr, d.m.Size)
+		scancancel()
+		if err != nil && conf.IgnoreErrors {
+			log.Infox("external spam scan failed, continuing without its score", err)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+// spamScanCombine combines the probability from the junk filter with the average
+// probability of the external spam scanners, scaled to the junk threshold.
+func spamScanCombine(log mlog.Log, prob float64, scans []spamscan.Result, threshold float64) float64 {
+	weight := mox.Conf.Static.SpamScan.Weight
+	if weight == 0 {
+		weight = 0.5
+	}
+	var scanProb float64
+	for _, r := range scans {
+		scanProb += r.Probability(threshold)
+	}
+	scanProb /= float64(len(scans))
+	combined := (1-weight)*prob + weight*scanProb
+	log.Debug("combined junk probability with external spam scanners",
+		slog.Float64("prob", prob),
+		slog.Float64("scanprob", scanProb),
+		slog.Float64("combined", combined))
+	return combined
+}
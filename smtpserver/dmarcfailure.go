@@ -0,0 +1,137 @@
+package smtpserver
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"os"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mjl-/mox/arf"
+	"github.com/mjl-/mox/dkim"
+	"github.com/mjl-/mox/dmarc"
+	"github.com/mjl-/mox/dmarcdb"
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/moxio"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/spf"
+)
+
+// dmarcFailureReportFields returns the fields for a DMARC failure report about a
+// message, and whether a report is requested through the failure reporting
+// options (fo=) of the DMARC record.
+func dmarcFailureReportFields(dmarcResult dmarc.Result, dkimResults []dkim.Result, spfStatus spf.Status) (r arf.Report, requested bool) {
+	fo := dmarcResult.Record.FailureReportingOptions
+	if len(fo) == 0 {
+		fo = []string{"0"}
+	}
+
+	// Failing DKIM signature, regardless of alignment.
+	var dkimFail *dkim.Result
+	for i, dr := range dkimResults {
+		if dr.Status == dkim.StatusFail && dr.Sig != nil {
+			dkimFail = &dkimResults[i]
+			break
+		}
+	}
+
+	switch {
+	case slices.Contains(fo, "0") && !dmarcResult.AlignedDKIMPass && !dmarcResult.AlignedSPFPass,
+		slices.Contains(fo, "1") && (!dmarcResult.AlignedDKIMPass || !dmarcResult.AlignedSPFPass):
+		r.AuthFailure = "dmarc"
+	case slices.Contains(fo, "d") && dkimFail != nil:
+		r.AuthFailure = "signature"
+		if errors.Is(dkimFail.Err, dkim.ErrBodyhashMismatch) {
+			r.AuthFailure = "bodyhash"
+		}
+	case slices.Contains(fo, "s") && spfStatus == spf.StatusFail:
+		r.AuthFailure = "spf"
+	default:
+		return r, false
+	}
+
+	if dkimFail != nil {
+		r.DKIMDomain = dkimFail.Sig.Domain.ASCII
+		r.DKIMSelector = dkimFail.Sig.Selector.ASCII
+		if dkimFail.Sig.Identity != nil {
+			r.DKIMIdentity = dkimFail.Sig.Identity.String()
+		}
+	}
+
+	var aligned []string
+	if dmarcResult.AlignedDKIMPass {
+		aligned = append(aligned, "dkim")
+	}
+	if dmarcResult.AlignedSPFPass {
+		aligned = append(aligned, "spf")
+	}
+	if len(aligned) == 0 {
+		aligned = []string{"none"}
+	}
+	r.IdentityAlignment = strings.Join(aligned, ", ")
+	return r, true
+}
+
+// dmarcFailureReport sends a DMARC failure report in the background for a
+// message that failed DMARC checks, if the DMARC record asks for failure reports
+// and sending failure reports is enabled for the policy domain. The original
+// message is redacted before returning, so dataFile can be removed after.
+func dmarcFailureReport(log mlog.Log, resolver dns.Resolver, dmarcResult dmarc.Result, dkimResults []dkim.Result, spfStatus spf.Status, authResults message.AuthResults, remoteIP net.IP, mailFrom smtp.Path, received time.Time, deliveryResult string, dataFile *os.File) {
+	if dmarcResult.Record == nil || len(dmarcResult.Record.FailureReportAddresses) == 0 {
+		return
+	}
+	dc, ok := dmarcdb.FailureReportDomain(dmarcResult.Domain)
+	if !ok {
+		return
+	}
+	report, requested := dmarcFailureReportFields(dmarcResult, dkimResults, spfStatus)
+	if !requested {
+		return
+	}
+
+	original, hasBody, err := dmarcdb.FailureReportRedact(dc, &moxio.AtReader{R: dataFile})
+	if err != nil {
+		log.Errorx("redacting message for dmarc failure report", err)
+		return
+	}
+
+	report.OriginalMailFrom = mailFrom.String()
+	report.ArrivalDate = received
+	report.SourceIP = remoteIP.String()
+	report.DeliveryResult = deliveryResult
+	report.AuthenticationResults = []string{strings.Join(strings.Fields(strings.TrimPrefix(authResults.Header(), "Authentication-Results:")), " ")}
+
+	fr := dmarcdb.FailureReport{
+		PolicyDomain: dmarcResult.Domain,
+		Record:       dmarcResult.Record,
+		Report:       report,
+		Original:     original,
+		HasBody:      hasBody,
+	}
+
+	// Looking up reporting addresses and queueing can take a while, don't hold up the
+	// SMTP transaction.
+	go func() {
+		defer func() {
+			x := recover() // Should not happen, but don't take program down if it does.
+			if x != nil {
+				log.Error("dmarc failure report panic", slog.Any("err", x))
+				debug.PrintStack()
+				metrics.PanicInc(metrics.Dmarcdb)
+			}
+		}()
+
+		ctx, cancel := context.WithTimeout(mox.Shutdown, time.Minute)
+		defer cancel()
+		err := dmarcdb.SendFailureReport(ctx, log, resolver, fr)
+		log.Check(err, "sending dmarc failure report", slog.Any("policydomain", fr.PolicyDomain))
+	}()
+}
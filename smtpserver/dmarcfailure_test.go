@@ -0,0 +1,34 @@
+package smtpserver
+
+import (
+	"testing"
+
+	"github.com/mjl-/mox/dkim"
+	"github.com/mjl-/mox/dmarc"
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/spf"
+)
+
+func TestDMARCFailureReportFields(t *testing.T) {
+	sig := &dkim.Sig{Domain: dns.Domain{ASCII: "example.org"}, Selector: dns.Domain{ASCII: "sel"}}
+	dkimFail := []dkim.Result{{Status: dkim.StatusFail, Sig: sig, Err: dkim.ErrBodyhashMismatch}}
+
+	test := func(fo []string, alignedDKIM, alignedSPF bool, dkimResults []dkim.Result, spfStatus spf.Status, expAuthFailure, expAlignment string) {
+		t.Helper()
+		record := dmarc.DefaultRecord
+		record.FailureReportingOptions = fo
+		result := dmarc.Result{Record: &record, AlignedDKIMPass: alignedDKIM, AlignedSPFPass: alignedSPF}
+		r, requested := dmarcFailureReportFields(result, dkimResults, spfStatus)
+		if requested != (expAuthFailure != "") || r.AuthFailure != expAuthFailure || requested && r.IdentityAlignment != expAlignment {
+			t.Fatalf("got requested %v, auth failure %q, alignment %q, expected auth failure %q, alignment %q", requested, r.AuthFailure, r.IdentityAlignment, expAuthFailure, expAlignment)
+		}
+	}
+
+	test(nil, false, false, nil, spf.StatusNone, "dmarc", "none")
+	test([]string{"0"}, true, false, nil, spf.StatusFail, "", "")
+	test([]string{"1"}, true, false, nil, spf.StatusNone, "dmarc", "dkim")
+	test([]string{"1"}, true, true, dkimFail, spf.StatusPass, "", "")
+	test([]string{"d"}, false, true, dkimFail, spf.StatusPass, "bodyhash", "spf")
+	test([]string{"d", "s"}, true, false, nil, spf.StatusFail, "spf", "dkim")
+	test([]string{"s"}, true, true, nil, spf.StatusSoftfail, "", "")
+}
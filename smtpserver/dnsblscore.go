@@ -0,0 +1,216 @@
+package smtpserver
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/dnsbl"
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+)
+
+var metricDNSBLScoreLookup = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "mox_smtpserver_dnsbl_score_lookup_total",
+		Help: "Lookups of IPs of incoming messages in DNS block and allow lists for scoring, per zone, including cached results. Result is listed, notlisted, error or unhealthy.",
+	},
+	[]string{
+		"zone",
+		"result",
+	},
+)
+
+type dnsblCacheKey struct {
+	zone dns.Domain
+	ip   string
+}
+
+type dnsblCacheEntry struct {
+	listed      bool
+	explanation string
+	expires     time.Time
+}
+
+// Cache of lookup results, without temporary errors.
+var dnsblCache = struct {
+	sync.Mutex
+	entries map[dnsblCacheKey]dnsblCacheEntry
+}{
+	entries: map[dnsblCacheKey]dnsblCacheEntry{},
+}
+
+// dnsblListing is the result of a lookup of an IP in a scoring list.
+type dnsblListing struct {
+	list        config.DNSBLScoreList
+	result      string // "listed", "notlisted", "error" or "unhealthy".
+	explanation string
+}
+
+// dnsblScoring is the outcome of looking up an IP in the scoring lists.
+type dnsblScoring struct {
+	score    float64
+	listings []dnsblListing
+}
+
+// header returns an X-Mox-DNSBL-Score header with the score and results per list.
+func (s dnsblScoring) header(hostname dns.Domain) string {
+	l := []string{fmt.Sprintf("score=%.2f", s.score)}
+	for _, dl := range s.listings {
+		v := fmt.Sprintf("%s=%s", dl.list.ZoneDomain.ASCII, dl.result)
+		if dl.result == "listed" {
+			v += fmt.Sprintf(" (%+.2f)", dl.list.Weight)
+		}
+		l = append(l, v)
+	}
+	return fmt.Sprintf("X-Mox-DNSBL-Score: %s;\r\n\t%s\r\n", hostname.ASCII, strings.Join(l, ";\r\n\t"))
+}
+
+// dnsblScore looks up ip in the lists of the scoring configuration concurrently,
+// summing the weights of the lists that contain ip.
+func dnsblScore(ctx context.Context, log mlog.Log, resolver dns.Resolver, conf *config.DNSBLScoring, ip net.IP) dnsblScoring {
+	ttl := conf.CacheTTL
+	if ttl == 0 {
+		ttl = time.Hour
+	}
+
+	listings := make([]dnsblListing, len(conf.Lists))
+	var wg sync.WaitGroup
+	for i, l := range conf.Lists {
+		wg.Add(1)
+		go func(i int, l config.DNSBLScoreList) {
+			defer func() {
+				x := recover() // Should not happen, but don't take program down if it does.
+				if x != nil {
+					log.Error("dnsbl scoring lookup panic", slog.Any("err", x))
+					debug.PrintStack()
+					metrics.PanicInc(metrics.Smtpserver)
+					listings[i] = dnsblListing{list: l, result: "error"}
+				}
+			}()
+			defer wg.Done()
+
+			lookupctx, lookupcancel := context.WithTimeout(ctx, 30*time.Second)
+			defer lookupcancel()
+			listings[i] = dnsblLookupCached(lookupctx, log, resolver, l, ip, ttl)
+		}(i, l)
+	}
+	wg.Wait()
+
+	var s dnsblScoring
+	s.listings = listings
+	for _, dl := range listings {
+		metricDNSBLScoreLookup.WithLabelValues(dl.list.ZoneDomain.Name(), dl.result).Inc()
+		if dl.result == "listed" {
+			s.score += dl.list.Weight
+		}
+	}
+	return s
+}
+
+// dnsblLookupCached looks up ip in a scoring list, using and updating the cache.
+// Unhealthy lists are not queried.
+func dnsblLookupCached(ctx context.Context, log mlog.Log, resolver dns.Resolver, l config.DNSBLScoreList, ip net.IP, ttl time.Duration) dnsblListing {
+	key := dnsblCacheKey{l.ZoneDomain, ip.String()}
+	now := time.Now()
+
+	dnsblCache.Lock()
+	e, ok := dnsblCache.entries[key]
+	dnsblCache.Unlock()
+	if ok && now.Before(e.expires) {
+		return dnsblListing{l, dnsblResult(e.listed), e.explanation}
+	}
+
+	if !checkDNSBLHealth(ctx, log, resolver, l.ZoneDomain) {
+		log.Info("dnsbl not healthy, skipping for scoring", slog.Any("zone", l.ZoneDomain))
+		return dnsblListing{list: l, result: "unhealthy"}
+	}
+	status, expl, err := dnsbl.Lookup(ctx, log.Logger, resolver, l.ZoneDomain, ip)
+	if status == dnsbl.StatusTemperr || status != dnsbl.StatusFail && err != nil {
+		log.Infox("dnsbl lookup for scoring", err, slog.Any("zone", l.ZoneDomain), slog.Any("status", status))
+		return dnsblListing{list: l, result: "error"}
+	}
+
+	listed := status == dnsbl.StatusFail
+	dnsblCache.Lock()
+	defer dnsblCache.Unlock()
+	// Prevent unbounded growth. Entries only live for the ttl, so we just start over.
+	if len(dnsblCache.entries) >= 100*1000 {
+		dnsblCache.entries = map[dnsblCacheKey]dnsblCacheEntry{}
+	}
+	dnsblCache.entries[key] = dnsblCacheEntry{listed, expl, now.Add(ttl)}
+	return dnsblListing{l, dnsblResult(listed), expl}
+}
+
+func dnsblResult(listed bool) string {
+	if listed {
+		return "listed"
+	}
+	return "notlisted"
+}
+
+type greylistKey struct {
+	network  string // Masked remote IP.
+	mailFrom string
+	rcptTo   string
+}
+
+type greylistEntry struct {
+	first  time.Time
+	last   time.Time
+	passed bool
+}
+
+// Greylisting state, kept in memory. After a restart, senders are greylisted
+// again.
+var greylist = struct {
+	sync.Mutex
+	entries map[greylistKey]greylistEntry
+	cleaned time.Time
+}{
+	entries: map[greylistKey]greylistEntry{},
+}
+
+// greylistPass returns whether a message for key can pass greylisting: it was
+// first seen at least delay ago (but not more than a day ago), or passed
+// before. The first attempt is recorded.
+func greylistPass(key greylistKey, delay time.Duration, now time.Time) bool {
+	greylist.Lock()
+	defer greylist.Unlock()
+
+	if now.Sub(greylist.cleaned) > time.Hour {
+		for k, e := range greylist.entries {
+			if e.passed && now.Sub(e.last) > 36*24*time.Hour || !e.passed && now.Sub(e.first) > 24*time.Hour {
+				delete(greylist.entries, k)
+			}
+		}
+		greylist.cleaned = now
+	}
+
+	e, ok := greylist.entries[key]
+	switch {
+	case ok && e.passed:
+	case !ok || now.Sub(e.first) > 24*time.Hour:
+		greylist.entries[key] = greylistEntry{first: now, last: now}
+		return false
+	case now.Sub(e.first) < delay:
+		e.last = now
+		greylist.entries[key] = e
+		return false
+	default:
+		e.passed = true
+	}
+	e.last = now
+	greylist.entries[key] = e
+	return true
+}
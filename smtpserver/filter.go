@@ -0,0 +1,40 @@
+package smtpserver
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/smtp"
+)
+
+// forwardFiltered queues a copy of the incoming message for d to forwardTo, for a
+// matching filter rule of the account. The message is sent with the recipient
+// address as MAIL FROM, so delivery failures are reported to the account.
+func forwardFiltered(ctx context.Context, log mlog.Log, d delivery, forwardTo string, msgWriter *message.Writer, smtputf8 bool, messageID string, envelope *message.Envelope, dataFile *os.File) {
+	addr, err := smtp.ParseAddress(forwardTo)
+	if err != nil {
+		log.Errorx("parsing forward address of filter rule", err, slog.String("forwardto", forwardTo))
+		return
+	}
+	rcptTo := addr.Path()
+	if rcptTo.Equal(d.deliverTo) {
+		log.Info("not forwarding message to recipient address itself", slog.Any("forwardto", rcptTo))
+		return
+	}
+
+	var subject string
+	if envelope != nil {
+		subject = envelope.Subject
+	}
+	qm := queue.MakeMsg(d.deliverTo, rcptTo, msgWriter.Has8bit, smtputf8, d.m.Size, messageID, d.m.Sealed.MsgPrefix, nil, time.Now(), subject)
+	if err := queue.Add(ctx, log, d.acc.Name, dataFile, qm); err != nil {
+		log.Errorx("queueing message for forwarding by filter rule", err, slog.Any("forwardto", rcptTo))
+		return
+	}
+	log.Info("message queued for forwarding by filter rule", slog.Any("forwardto", rcptTo))
+}
@@ -0,0 +1,218 @@
+package smtpserver
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mjl-/mox/admindb"
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/secondarymx"
+	"github.com/mjl-/mox/smtp"
+)
+
+// forward queues an incoming message received by a frontend node for delivery to
+// the storage node, through the storage transport. The storage node evaluates the
+// message as if it was received directly from the remote, through XCLIENT.
+func (c *conn) forward(ctx context.Context, recvHdrFor func(string) string, msgWriter *message.Writer, dataFile *os.File) {
+	// The storage node has the same accounts, and checks the recipients again. We only
+	// reject messages for which all recipients are unknown, like during delivery.
+	nunknown := 0
+	for _, r := range c.recipients {
+		if r.account == nil && r.alias == nil && r.spamtrap == "" {
+			nunknown++
+		}
+	}
+	if nunknown == len(c.recipients) {
+		c.log.Info("forward attempt to unknown user(s)", slog.Any("recipients", c.recipients))
+		if unknownRecipientsDelay > 0 {
+			mox.Sleep(ctx, unknownRecipientsDelay)
+		}
+		xsmtpUserErrorf(smtp.C550MailboxUnavail, smtp.SeAddr1UnknownDestMailbox1, "no such user(s)")
+	}
+
+	c.xqueueForward(ctx, recvHdrFor, msgWriter, dataFile, "storage node", func(rcpt smtp.Path) (string, bool) {
+		return mox.Conf.Static.Node.StorageTransport, true
+	})
+}
+
+// xsecondaryMXRecipient adds a recipient for a domain we are secondary MX for,
+// if it is in the address list of the domain.
+func (c *conn) xsecondaryMXRecipient(sd config.SecondaryMXDomain, fpath smtp.Path) {
+	if len(c.recipients) > c.secondaryMX {
+		// The remote will try again in a new transaction.
+		xsmtpUserErrorf(smtp.C452StorageFull, smtp.SeProto5TooManyRcpts3, "recipients for secondary mx domains must be in a separate transaction")
+	}
+	ok, err := secondarymx.Accepts(sd, smtp.Address{Localpart: fpath.Localpart, Domain: fpath.IPDomain.Domain})
+	if err != nil {
+		c.log.Errorx("checking address list for secondary mx domain", err, slog.Any("rcptto", fpath))
+		xsmtpServerErrorf(codes{smtp.C451LocalErr, smtp.SeSys3Other0}, "error processing")
+	} else if !ok {
+		// As secondary MX, we don't want to accept messages we cannot deliver, so we
+		// reject unknown addresses immediately.
+		xsmtpUserErrorf(smtp.C550MailboxUnavail, smtp.SeAddr1UnknownDestMailbox1, "no such user")
+	}
+	c.recipients = append(c.recipients, recipient{fpath, nil, nil, ""})
+	c.secondaryMX++
+}
+
+// forwardSecondaryMX queues an incoming message for domains we are secondary MX
+// for, for delivery to the primary through the transport of the domain.
+func (c *conn) forwardSecondaryMX(ctx context.Context, recvHdrFor func(string) string, msgWriter *message.Writer, dataFile *os.File) {
+	c.xqueueForward(ctx, recvHdrFor, msgWriter, dataFile, "primary", func(rcpt smtp.Path) (string, bool) {
+		sd, ok := secondarymx.Domain(rcpt.IPDomain.Domain)
+		if !ok {
+			// Configuration cannot change while running.
+			xsmtpServerErrorf(codes{smtp.C451LocalErr, smtp.SeSys3Other0}, "internal error: secondary mx domain not found")
+		}
+		return sd.Transport, sd.XClient
+	})
+}
+
+// xqueueForward queues the message for the recipients for delivery to another mail
+// server (dest, for logging), with the transport returned by msgTransport for each
+// recipient, and optionally with the remote IP and EHLO hostname for XCLIENT. The
+// messages are queued without sender account.
+func (c *conn) xqueueForward(ctx context.Context, recvHdrFor func(string) string, msgWriter *message.Writer, dataFile *os.File, dest string, msgTransport func(rcpt smtp.Path) (string, bool)) {
+	var messageID, subject string
+	part, err := message.Parse(c.log.Logger, false, dataFile)
+	if err == nil {
+		var headers textproto.MIMEHeader
+		headers, err = part.Header()
+		if err == nil {
+			// Basic loop detection. ../rfc/5321:4065 ../rfc/5321:1526
+			if len(headers.Values("Received")) > 100 {
+				xsmtpUserErrorf(smtp.C550MailboxUnavail, smtp.SeNet4Loop6, "loop detected, more than 100 Received headers")
+			}
+			messageID = headers.Get("Message-Id")
+			subject = headers.Get("Subject")
+		}
+	}
+	if err != nil {
+		c.log.Infox("parsing message header for forwarding", err)
+	}
+
+	now := time.Now()
+	qml := make([]queue.Msg, len(c.recipients))
+	for i, rcpt := range c.recipients {
+		var rcptTo string
+		if len(c.recipients) == 1 {
+			rcptTo = rcpt.addr.String()
+		}
+		prefix := []byte(recvHdrFor(rcptTo))
+		qm := queue.MakeMsg(*c.mailFrom, rcpt.addr, msgWriter.Has8bit, c.msgsmtputf8, int64(len(prefix))+msgWriter.Size, messageID, prefix, c.requireTLS, now, subject)
+		var xclient bool
+		qm.Transport, xclient = msgTransport(rcpt.addr)
+		if xclient {
+			qm.XClientAddr = c.remoteIP.String()
+			qm.XClientHelo = c.hello.String()
+		}
+		qml[i] = qm
+	}
+
+	admindb.MessageEventAdd(ctx, c.log, admindb.MessageEvent{
+		MessageID: messageID,
+		Kind:      admindb.EventReceived,
+		Remote:    c.remoteIP.String(),
+		Detail:    c.receivedEventDetail(msgWriter.Size) + ", forwarding to " + dest,
+	})
+
+	if err := queue.Add(ctx, c.log, "", dataFile, qml...); err != nil {
+		metricDelivery.WithLabelValues("forwarderror", "").Inc()
+		c.log.Errorx("queuing message for forwarding to "+dest, err)
+		xsmtpServerErrorf(errCodes(smtp.C451LocalErr, smtp.SeSys3Other0, err), "error forwarding message: %v", err)
+	}
+	metricDelivery.WithLabelValues("forwarded", "").Inc()
+	c.log.Info("message queued for forwarding to "+dest,
+		slog.Any("mailfrom", *c.mailFrom),
+		slog.Any("recipients", c.recipients),
+		slog.Int64("msgsize", msgWriter.Size))
+
+	c.transactionGood++
+	c.transactionBad-- // Compensate for early earlier pessimistic increase.
+
+	c.rset()
+	c.writecodeline(smtp.C250Completed, smtp.SeMailbox2Other0, "it is done", nil)
+}
+
+// xclientAllowed returns whether the remote is a frontend node that can use the
+// XCLIENT command. Only for connections to a storage node, not for submission.
+func (c *conn) xclientAllowed() bool {
+	n := mox.Conf.Static.Node
+	if n == nil || n.Role != "storage" || c.submission || c.xclient {
+		return false
+	}
+	for _, ipnet := range n.FrontendNets {
+		if ipnet.Contains(c.remoteIP) {
+			return true
+		}
+	}
+	return false
+}
+
+// XCLIENT is an extension by Postfix, https://www.postfix.org/XCLIENT_README.html.
+// Frontend nodes use it to pass the remote IP and EHLO hostname of the original
+// SMTP connection of a message they forward. The message is then evaluated as if
+// it was received from the original remote. Only attributes ADDR and HELO are
+// used, others are ignored.
+func (c *conn) cmdXclient(p *parser) {
+	if !c.xclientAllowed() {
+		xsmtpUserErrorf(smtp.C550MailboxUnavail, smtp.SePol7Other0, "xclient not allowed")
+	}
+	if c.mailFrom != nil {
+		xsmtpUserErrorf(smtp.C503BadCmdSeq, smtp.SeProto5BadCmdOrSeq1, "xclient not allowed during mail transaction")
+	}
+
+	var addr net.IP
+	var hello dns.IPDomain
+	for p.space() {
+		key := p.xparamKeyword()
+		p.xtake("=")
+		v := p.xtext()
+		if v == "[UNAVAILABLE]" || v == "[TEMPUNAVAIL]" {
+			continue
+		}
+		switch strings.ToUpper(key) {
+		case "ADDR":
+			if len(v) > 5 && strings.EqualFold(v[:5], "IPV6:") {
+				v = v[5:]
+			}
+			addr = net.ParseIP(v)
+			if addr == nil {
+				xsmtpUserErrorf(smtp.C501BadParamSyntax, smtp.SeProto5Syntax2, "invalid ip address for attribute addr")
+			}
+		case "HELO":
+			if ip := net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(v, "["), "]")); ip != nil {
+				hello = dns.IPDomain{IP: ip}
+			} else if d, err := dns.ParseDomain(v); err != nil {
+				xsmtpUserErrorf(smtp.C501BadParamSyntax, smtp.SeProto5Syntax2, "invalid hostname for attribute helo: %v", err)
+			} else {
+				hello = dns.IPDomain{Domain: d}
+			}
+		}
+	}
+	p.xend()
+	if addr == nil {
+		xsmtpUserErrorf(smtp.C501BadParamSyntax, smtp.SeProto5Syntax2, "missing attribute addr")
+	}
+
+	c.log.Debug("xclient from frontend node", slog.Any("frontend", c.remoteIP), slog.Any("remoteip", addr), slog.Any("hello", hello))
+	c.remoteIP = addr
+	if !hello.IsZero() {
+		c.hello = hello
+	} else {
+		c.hello = dns.IPDomain{IP: addr}
+	}
+	c.xclient = true
+	c.rset()
+	// The client must send EHLO again, as after the greeting.
+	c.writelinef("%d %s ESMTP mox", smtp.C220ServiceReady, c.hostname.ASCII)
+}
@@ -0,0 +1,80 @@
+package smtpserver
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/mox/admindb"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/smtp"
+)
+
+var metricLineEndingAnomaly = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "mox_smtpserver_line_ending_anomaly_total",
+		Help: "Messages with line ending anomalies in the SMTP message data, by anomaly and action.",
+	},
+	[]string{
+		"anomaly", // barecr, barelf, baddataend
+		"action",  // reject, log
+	},
+)
+
+// lineEndingConfig sets whether the data reader rejects line ending anomalies,
+// according to the configuration.
+func lineEndingConfig(dr *smtp.DataReader) {
+	if le := mox.Conf.Static.SMTPLineEndings; le != nil {
+		dr.RejectBareCR = le.BareCR != "log"
+		dr.RejectBareLF = le.BareLF == "reject"
+		dr.RejectBadDataEnd = le.BadDataEnd != "log"
+	}
+}
+
+// lineEndingAnomalies logs and counts line ending anomalies seen in the message
+// data, in the metrics and per remote IP in the admin database.
+func (c *conn) lineEndingAnomalies(ctx context.Context, dr *smtp.DataReader) {
+	if dr.BareCR == 0 && dr.BareLF == 0 && dr.BadDataEnd == 0 {
+		return
+	}
+
+	rejected := dr.Rejected()
+	src := admindb.LineEndingSource{
+		RemoteIP:   c.remoteIP.String(),
+		EHLO:       c.hello.String(),
+		Submission: c.submission,
+		Messages:   1,
+	}
+	if c.mailFrom != nil {
+		src.MailFromDomain = c.mailFrom.IPDomain.String()
+	}
+	if rejected {
+		src.Rejected = 1
+	}
+	count := func(anomaly string, n int, reject bool, counter *int64) {
+		if n == 0 {
+			return
+		}
+		action := "log"
+		if reject {
+			action = "reject"
+		}
+		metricLineEndingAnomaly.WithLabelValues(anomaly, action).Inc()
+		*counter = 1
+	}
+	count("barecr", dr.BareCR, dr.RejectBareCR, &src.BareCR)
+	count("barelf", dr.BareLF, dr.RejectBareLF, &src.BareLF)
+	count("baddataend", dr.BadDataEnd, dr.RejectBadDataEnd, &src.BadDataEnd)
+
+	c.log.Info("line ending anomalies in message data",
+		slog.Int("barecr", dr.BareCR),
+		slog.Int("barelf", dr.BareLF),
+		slog.Int("baddataend", dr.BadDataEnd),
+		slog.Bool("rejected", rejected),
+		slog.String("ehlo", src.EHLO),
+		slog.String("mailfromdomain", src.MailFromDomain))
+	err := admindb.LineEndingSourceAdd(ctx, src)
+	c.log.Check(err, "adding line ending anomaly source to database")
+}
@@ -19,7 +19,7 @@ import (
 
 // rejectPresent returns whether the message is already present in the rejects mailbox.
 func rejectPresent(log mlog.Log, acc *store.Account, rejectsMailbox string, m *store.Message, f *os.File) (present bool, msgID string, hash []byte, rerr error) {
-	if p, err := message.Parse(log.Logger, false, store.FileMsgReader(m.MsgPrefix, f)); err != nil {
+	if p, err := message.Parse(log.Logger, false, store.FileMsgReader(m.Sealed.MsgPrefix, f)); err != nil {
 		log.Infox("parsing reject message for message-id", err)
 	} else if header, err := p.Header(); err != nil {
 		log.Infox("parsing reject message header for message-id", err)
@@ -28,6 +28,7 @@ func rejectPresent(log mlog.Log, acc *store.Account, rejectsMailbox string, m *s
 		if err != nil {
 			log.Debugx("parsing message-id for reject", err, slog.String("messageid", header.Get("Message-Id")))
 		}
+		msgID = store.ThreadKey(acc.Name, msgID)
 	}
 
 	// We must not read MsgPrefix, it will likely change for subsequent deliveries.
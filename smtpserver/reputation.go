@@ -9,7 +9,6 @@ import (
 	"github.com/mjl-/bstore"
 
 	"github.com/mjl-/mox/mlog"
-	"github.com/mjl-/mox/smtp"
 	"github.com/mjl-/mox/store"
 )
 
@@ -97,6 +96,8 @@ const (
 // ../rfc/6376:1915
 // ../rfc/6376:3716
 // ../rfc/7208:2167
+//
+// The address keys of m must be set, see store.Message.PrepareAccount.
 func reputation(tx *bstore.Tx, log mlog.Log, m *store.Message) (rjunk *bool, rconclusive bool, rmethod reputationMethod, rerr error) {
 	boolptr := func(v bool) *bool {
 		return &v
@@ -172,7 +173,7 @@ func reputation(tx *bstore.Tx, log mlog.Log, m *store.Message) (rjunk *bool, rco
 	//
 	// If there was no validation, any signal is inconclusive.
 	if m.MsgFromDomain != "" {
-		q := messageQuery(&store.Message{MsgFromLocalpart: m.MsgFromLocalpart, MsgFromDomain: m.MsgFromDomain}, 3*year, 2)
+		q := messageQuery(&store.Message{MsgFromAddressKey: m.MsgFromAddressKey}, 3*year, 2)
 		q.FilterEqual("MsgFromValidated", m.MsgFromValidated)
 		msgs := xmessageList(q, "mgsfromfull")
 		if len(msgs) > 0 {
@@ -185,7 +186,7 @@ func reputation(tx *bstore.Tx, log mlog.Log, m *store.Message) (rjunk *bool, rco
 			// Look for historic messages that were validated. If present, this is likely spam.
 			// Only return as conclusively spam if history also says this From-address sent
 			// spam.
-			q := messageQuery(&store.Message{MsgFromLocalpart: m.MsgFromLocalpart, MsgFromDomain: m.MsgFromDomain, MsgFromValidated: true}, 3*year, 2)
+			q := messageQuery(&store.Message{MsgFromAddressKey: m.MsgFromAddressKey, MsgFromValidated: true}, 3*year, 2)
 			msgs = xmessageList(q, "msgfromfull-validated")
 			if len(msgs) > 0 {
 				spam := msgs[0].Junk && (len(msgs) == 1 || msgs[1].Junk)
@@ -195,7 +196,7 @@ func reputation(tx *bstore.Tx, log mlog.Log, m *store.Message) (rjunk *bool, rco
 
 		// Look if we ever sent to this address. If so, we accept,
 		qr := bstore.QueryTx[store.Recipient](tx)
-		qr.FilterEqual("Localpart", m.MsgFromLocalpart)
+		qr.FilterEqual("Localpart", m.Sealed.MsgFromLocalpart)
 		qr.FilterEqual("Domain", m.MsgFromDomain)
 		qr.FilterGreaterEqual("Sent", now.Add(-3*year))
 		if xrecipientExists(qr) {
@@ -232,10 +233,10 @@ func reputation(tx *bstore.Tx, log mlog.Log, m *store.Message) (rjunk *bool, rco
 				}
 				if nonjunk == 0 {
 					// Only conclusive with at least 3 different localparts.
-					localparts := map[smtp.Localpart]struct{}{}
+					addresses := map[string]struct{}{}
 					for _, m := range msgs {
-						localparts[m.MsgFromLocalpart] = struct{}{}
-						if len(localparts) == 3 {
+						addresses[m.MsgFromAddressKey] = struct{}{}
+						if len(addresses) == 3 {
 							return xtrue, true, method, nil
 						}
 					}
@@ -297,7 +298,7 @@ func reputation(tx *bstore.Tx, log mlog.Log, m *store.Message) (rjunk *bool, rco
 	if m.MailFromValidated || m.EHLOValidated {
 		var msgs []store.Message
 		if m.MailFromValidated && m.MailFromDomain != "" {
-			q := messageQuery(&store.Message{MailFromLocalpart: m.MailFromLocalpart, MailFromDomain: m.MailFromDomain}, year/2, 50)
+			q := messageQuery(&store.Message{MailFromAddressKey: m.MailFromAddressKey}, year/2, 50)
 			msgs = xmessageList(q, "mailfrom")
 			if len(msgs) == 0 {
 				q := messageQuery(&store.Message{MailFromDomain: m.MailFromDomain}, year/2, 50)
@@ -73,14 +73,9 @@ func TestReputation(t *testing.T) {
 			RemoteIPMasked2: ipmasked2,
 			RemoteIPMasked3: ipmasked3,
 
-			EHLODomain:        ehlo,
-			MailFrom:          mailfrom,
-			MailFromLocalpart: mailFrom.Localpart,
-			MailFromDomain:    mailFrom.Domain.Name(),
-			RcptToLocalpart:   rcptTo.Localpart,
-			RcptToDomain:      rcptTo.Domain.Name(),
-
-			MsgFromLocalpart: msgFrom.Localpart,
+			EHLODomain:     ehlo,
+			MailFromDomain: mailFrom.Domain.Name(),
+
 			MsgFromDomain:    msgFrom.Domain.Name(),
 			MsgFromOrgDomain: publicsuffix.Lookup(ctxbg, log.Logger, msgFrom.Domain).Name(),
 
@@ -98,7 +93,15 @@ func TestReputation(t *testing.T) {
 				Junk:    junk,
 				Notjunk: !junk,
 			},
+			Sealed: store.MessageSealed{
+				MailFrom:          mailfrom,
+				MailFromLocalpart: mailFrom.Localpart,
+				RcptToLocalpart:   rcptTo.Localpart,
+				RcptToDomain:      rcptTo.Domain.Name(),
+				MsgFromLocalpart:  msgFrom.Localpart,
+			},
 		}
+		m.PrepareAccount("mjl")
 		return m
 	}
 
@@ -122,13 +125,13 @@ func TestReputation(t *testing.T) {
 				tcheck(t, err, "insert message")
 				inbox.Add(hm.MailboxCounts())
 
-				rcptToDomain, err := dns.ParseDomain(hm.RcptToDomain)
+				rcptToDomain, err := dns.ParseDomain(hm.Sealed.RcptToDomain)
 				tcheck(t, err, "parse rcptToDomain")
 				rcptToOrgDomain := publicsuffix.Lookup(ctxbg, log.Logger, rcptToDomain)
 				r := store.Recipient{
 					MessageID: hm.ID,
-					Localpart: hm.RcptToLocalpart.String(),
-					Domain:    hm.RcptToDomain,
+					Localpart: hm.Sealed.RcptToLocalpart.String(),
+					Domain:    hm.Sealed.RcptToDomain,
 					OrgDomain: rcptToOrgDomain.Name(),
 					Sent:      hm.Received,
 				}
@@ -0,0 +1,70 @@
+package smtpserver
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/mail"
+	"os"
+	"strings"
+
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/store"
+)
+
+// rewriteMsgFrom writes the message in dataFile of size to a new temporary file,
+// with the address in the From header replaced by from. The display name is kept.
+// The caller must close and remove the returned file.
+func rewriteMsgFrom(log mlog.Log, dataFile *os.File, size int64, part *message.Part, from smtp.Address, smtputf8 bool) (*os.File, int64, error) {
+	var name string
+	if part.Envelope != nil && len(part.Envelope.From) == 1 {
+		name = part.Envelope.From[0].Name
+	}
+
+	hbuf := make([]byte, part.BodyOffset)
+	if _, err := dataFile.ReadAt(hbuf, 0); err != nil {
+		return nil, 0, fmt.Errorf("reading message header: %v", err)
+	}
+
+	// Replace the first From field, including its continuation lines.
+	var b bytes.Buffer
+	var replaced, skip bool
+	for _, line := range strings.SplitAfter(string(hbuf), "\n") {
+		if line == "" {
+			continue
+		}
+		if line[0] != ' ' && line[0] != '\t' {
+			k, _, _ := strings.Cut(line, ":")
+			skip = !replaced && strings.EqualFold(strings.TrimSpace(k), "From")
+			if skip {
+				replaced = true
+				addr := mail.Address{Name: name, Address: from.Pack(smtputf8)}
+				fmt.Fprintf(&b, "From: %s\r\n", addr.String())
+			}
+		}
+		if !skip {
+			b.WriteString(line)
+		}
+	}
+	if !replaced {
+		return nil, 0, fmt.Errorf("no from header in message")
+	}
+
+	nf, err := store.CreateMessageTemp(log, "smtp-rewrite")
+	if err != nil {
+		return nil, 0, fmt.Errorf("creating temporary file: %v", err)
+	}
+	n, err := nf.Write(b.Bytes())
+	if err == nil {
+		var nn int64
+		nn, err = io.Copy(nf, io.NewSectionReader(dataFile, part.BodyOffset, size-part.BodyOffset))
+		n += int(nn)
+	}
+	if err != nil {
+		store.CloseRemoveTempFile(log, nf, "message with rewritten from")
+		return nil, 0, fmt.Errorf("writing message: %v", err)
+	}
+	return nf, int64(n), nil
+}
@@ -11,6 +11,7 @@ import (
 	"crypto/rsa"
 	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/sha512"
 	"crypto/tls"
 	"encoding/base64"
 	"errors"
@@ -38,28 +39,40 @@ import (
 
 	"github.com/mjl-/bstore"
 
+	"github.com/mjl-/mox/accounting"
+	"github.com/mjl-/mox/admindb"
 	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/contentbl"
 	"github.com/mjl-/mox/dkim"
 	"github.com/mjl-/mox/dmarc"
 	"github.com/mjl-/mox/dmarcdb"
 	"github.com/mjl-/mox/dmarcrpt"
 	"github.com/mjl-/mox/dns"
 	"github.com/mjl-/mox/dsn"
+	"github.com/mjl-/mox/footer"
 	"github.com/mjl-/mox/iprev"
 	"github.com/mjl-/mox/message"
 	"github.com/mjl-/mox/metrics"
 	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/moderation"
 	"github.com/mjl-/mox/mox-"
 	"github.com/mjl-/mox/moxio"
 	"github.com/mjl-/mox/moxvar"
+	"github.com/mjl-/mox/oidc"
 	"github.com/mjl-/mox/publicsuffix"
+	"github.com/mjl-/mox/quarantine"
 	"github.com/mjl-/mox/queue"
 	"github.com/mjl-/mox/ratelimit"
+	"github.com/mjl-/mox/sasl"
 	"github.com/mjl-/mox/scram"
+	"github.com/mjl-/mox/secondarymx"
+	"github.com/mjl-/mox/sendguard"
 	"github.com/mjl-/mox/smtp"
 	"github.com/mjl-/mox/spf"
 	"github.com/mjl-/mox/store"
+	"github.com/mjl-/mox/tlsfp"
 	"github.com/mjl-/mox/tlsrptdb"
+	"github.com/mjl-/mox/tracing"
 )
 
 // We use panic and recover for error handling while executing commands.
@@ -145,18 +158,29 @@ var (
 	metricDelivery = promauto.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "mox_smtpserver_delivery_total",
-			Help: "SMTP incoming message delivery from external source, not submission. Result values: delivered, reject, unknownuser, accounterror, delivererror. Reason indicates why a message was rejected/accepted.",
+			Help: "SMTP incoming message delivery from external source, not submission. Result values: delivered, reject, quarantined, moderated, spamtrap, unknownuser, accounterror, delivererror, forwarded, forwarderror. Reason indicates why a message was rejected/accepted.",
 		},
 		[]string{
 			"result",
 			"reason",
 		},
 	)
+	metricJunkClassification = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "mox_smtpserver_junk_probability",
+			Help:    "Junk filter probabilities of incoming messages from senders without reputation, classified by content.",
+			Buckets: []float64{0.05, 0.1, 0.25, 0.5, 0.75, 0.9, 0.95, 1},
+		},
+		[]string{
+			"result",    // ham, spam
+			"threshold", // normal, strict, known
+		},
+	)
 	// Similar between ../webmail/webmail.go:/metricSubmission and ../smtpserver/server.go:/metricSubmission and ../webapisrv/server.go:/metricSubmission
 	metricSubmission = promauto.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "mox_smtpserver_submission_total",
-			Help: "SMTP server incoming submission results, known values (those ending with error are server errors): ok, badmessage, badfrom, badheader, messagelimiterror, recipientlimiterror, localserveerror, queueerror.",
+			Help: "SMTP server incoming submission results, known values (those ending with error are server errors): ok, badmessage, badfrom, badheader, messagelimiterror, recipientlimiterror, guardrefused, localserveerror, queueerror.",
 		},
 		[]string{
 			"result",
@@ -165,7 +189,7 @@ var (
 	metricServerErrors = promauto.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "mox_smtpserver_errors_total",
-			Help: "SMTP server errors, known values: dkimsign, queuedsn.",
+			Help: "SMTP server errors, known values: dkimsign, queuedsn, footer, rewrite.",
 		},
 		[]string{
 			"error",
@@ -190,9 +214,13 @@ func Listen() {
 	for _, name := range names {
 		listener := mox.Conf.Static.Listeners[name]
 
-		var tlsConfig *tls.Config
+		var tlsConfig, submissionTLSConfig *tls.Config
 		if listener.TLS != nil {
 			tlsConfig = listener.TLS.Config
+			submissionTLSConfig = listener.TLS.Config
+			if listener.TLS.ClientAuthConfig != nil {
+				submissionTLSConfig = listener.TLS.ClientAuthConfig
+			}
 		}
 
 		maxMsgSize := listener.SMTPMaxMessageSize
@@ -208,7 +236,7 @@ func Listen() {
 			port := config.Port(listener.SMTP.Port, 25)
 			for _, ip := range listener.IPs {
 				firstTimeSenderDelay := durationDefault(listener.SMTP.FirstTimeSenderDelay, firstTimeSenderDelayDefault)
-				listen1("smtp", name, ip, port, hostname, tlsConfig, false, false, maxMsgSize, false, listener.SMTP.RequireSTARTTLS, !listener.SMTP.NoRequireTLS, listener.SMTP.DNSBLZones, firstTimeSenderDelay)
+				listen1("smtp", name, ip, port, hostname, tlsConfig, false, false, maxMsgSize, false, listener.SMTP.RequireSTARTTLS, !listener.SMTP.NoRequireTLS, false, listener.SMTP.DNSBLZones, firstTimeSenderDelay)
 			}
 		}
 		if listener.Submission.Enabled {
@@ -218,7 +246,7 @@ func Listen() {
 			}
 			port := config.Port(listener.Submission.Port, 587)
 			for _, ip := range listener.IPs {
-				listen1("submission", name, ip, port, hostname, tlsConfig, true, false, maxMsgSize, !listener.Submission.NoRequireSTARTTLS, !listener.Submission.NoRequireSTARTTLS, true, nil, 0)
+				listen1("submission", name, ip, port, hostname, submissionTLSConfig, true, false, maxMsgSize, !listener.Submission.NoRequireSTARTTLS, !listener.Submission.NoRequireSTARTTLS, true, listener.NoPlaintextAuth, nil, 0)
 			}
 		}
 
@@ -229,7 +257,7 @@ func Listen() {
 			}
 			port := config.Port(listener.Submissions.Port, 465)
 			for _, ip := range listener.IPs {
-				listen1("submissions", name, ip, port, hostname, tlsConfig, true, true, maxMsgSize, true, true, true, nil, 0)
+				listen1("submissions", name, ip, port, hostname, submissionTLSConfig, true, true, maxMsgSize, true, true, true, listener.NoPlaintextAuth, nil, 0)
 			}
 		}
 	}
@@ -237,7 +265,7 @@ func Listen() {
 
 var servers []func()
 
-func listen1(protocol, name, ip string, port int, hostname dns.Domain, tlsConfig *tls.Config, submission, xtls bool, maxMessageSize int64, requireTLSForAuth, requireTLSForDelivery, requireTLS bool, dnsBLs []dns.Domain, firstTimeSenderDelay time.Duration) {
+func listen1(protocol, name, ip string, port int, hostname dns.Domain, tlsConfig *tls.Config, submission, xtls bool, maxMessageSize int64, requireTLSForAuth, requireTLSForDelivery, requireTLS, noPlaintextAuth bool, dnsBLs []dns.Domain, firstTimeSenderDelay time.Duration) {
 	log := mlog.New("smtpserver", nil)
 	addr := net.JoinHostPort(ip, fmt.Sprintf("%d", port))
 	if os.Getuid() == 0 {
@@ -247,12 +275,19 @@ func listen1(protocol, name, ip string, port int, hostname dns.Domain, tlsConfig
 			slog.String("protocol", protocol))
 	}
 	network := mox.Network(ip)
-	ln, err := mox.Listen(network, addr)
+	listen := mox.Listen
+	if !submission && !mox.AutoBanSMTPDelivery() {
+		// Bans don't apply to incoming delivery, we don't want to refuse legitimate email.
+		listen = mox.ListenNoAutoBan
+	}
+	ln, err := listen(network, addr)
 	if err != nil {
 		log.Fatalx("smtp: listen for smtp", err, slog.String("protocol", protocol), slog.String("listener", name))
 	}
+	// Connections are wrapped in a tlsfp.Conn to fingerprint TLS clients.
+	tlsConfig = mox.TLSFingerprintConfig(tlsConfig, protocol)
 	if xtls {
-		ln = tls.NewListener(ln, tlsConfig)
+		ln = tls.NewListener(tlsfp.NewListener(ln), tlsConfig)
 	}
 
 	serve := func() {
@@ -264,8 +299,8 @@ func listen1(protocol, name, ip string, port int, hostname dns.Domain, tlsConfig
 			}
 
 			// Package is set on the resolver by the dkim/spf/dmarc/etc packages.
-			resolver := dns.StrictResolver{Log: log.Logger}
-			go serve(name, mox.Cid(), hostname, tlsConfig, conn, resolver, submission, xtls, maxMessageSize, requireTLSForAuth, requireTLSForDelivery, requireTLS, dnsBLs, firstTimeSenderDelay)
+			resolver := mox.CachingResolver(dns.StrictResolver{Log: log.Logger})
+			go serve(name, mox.Cid(), hostname, tlsConfig, conn, resolver, submission, xtls, maxMessageSize, requireTLSForAuth, requireTLSForDelivery, requireTLS, noPlaintextAuth, dnsBLs, firstTimeSenderDelay)
 		}
 	}
 
@@ -290,6 +325,7 @@ type conn struct {
 	conn     net.Conn
 
 	tls                   bool
+	immediateTLS          bool // Whether TLS started at connect, i.e. submissions instead of submission with STARTTLS.
 	extRequireTLS         bool // Whether to announce and allow the REQUIRETLS extension.
 	resolver              dns.Resolver
 	r                     *bufio.Reader
@@ -302,10 +338,14 @@ type conn struct {
 	tlsConfig             *tls.Config
 	localIP               net.IP
 	remoteIP              net.IP
+	listenerName          string
+	tlsHandshakeDuration  time.Duration     // Of STARTTLS, for tracing.
+	tlsFingerprint        tlsfp.Fingerprint // Of TLS client, if known.
 	hostname              dns.Domain
 	log                   mlog.Log
 	maxMessageSize        int64
 	requireTLSForAuth     bool
+	noPlaintextAuth       bool      // If set, AUTH PLAIN and LOGIN are not offered or accepted.
 	requireTLSForDelivery bool      // If set, delivery is only allowed with TLS (STARTTLS), except if delivery is to a TLS reporting address.
 	cmd                   string    // Current command.
 	cmdStart              time.Time // Start of current command.
@@ -317,12 +357,14 @@ type conn struct {
 	// command, we don't want the entire delivery to take too long.
 	deadline time.Time
 
-	hello dns.IPDomain // Claimed remote name. Can be ip address for ehlo.
-	ehlo  bool         // If set, we had EHLO instead of HELO.
+	hello   dns.IPDomain // Claimed remote name. Can be ip address for ehlo.
+	ehlo    bool         // If set, we had EHLO instead of HELO.
+	xclient bool         // If set, remoteIP and hello were set by a frontend node with XCLIENT.
 
 	authFailed int            // Number of failed auth attempts. For slowing down remote with many failures.
 	username   string         // Only when authenticated.
 	account    *store.Account // Only when authenticated.
+	sessionID  int64          // Of store.ProtocolSession, only when authenticated.
 
 	// We track good/bad message transactions to disconnect spammers trying to guess addresses.
 	transactionGood int
@@ -334,6 +376,7 @@ type conn struct {
 	futureRelease        time.Time // MAIL FROM with HOLDFOR or HOLDUNTIL.
 	futureReleaseRequest string    // For use in DSNs, either "for;" or "until;" plus original value. ../rfc/4865:305
 	has8bitmime          bool      // If MAIL FROM parameter BODY=8BITMIME was sent. Required for SMTPUTF8.
+	secondaryMX          int       // Number of recipients for domains we are secondary MX for. All or no recipients.
 	smtputf8             bool      // todo future: we should keep track of this per recipient. perhaps only a specific recipient requires smtputf8, e.g. due to a utf8 localpart.
 	msgsmtputf8          bool      // Is SMTPUTF8 required for the received message. Default to the same value as `smtputf8`, but is re-evaluated after the whole message (envelope and data) is received.
 	recipients           []recipient
@@ -358,6 +401,8 @@ type recipient struct {
 	// deliveries, this will result in an error.
 	account *rcptAccount // If set, recipient address is for this local account.
 	alias   *rcptAlias   // If set, for a local alias.
+
+	spamtrap string // If set, canonical spamtrap address. Messages are accepted but not delivered.
 }
 
 func isClosed(err error) bool {
@@ -371,6 +416,7 @@ func (c *conn) reset() {
 	c.hello = dns.IPDomain{}
 	c.username = ""
 	if c.account != nil {
+		c.sessionEnd()
 		err := c.account.Close()
 		c.log.Check(err, "closing account")
 	}
@@ -378,6 +424,34 @@ func (c *conn) reset() {
 	c.rset()
 }
 
+// sessionStart records the newly authenticated submission session, so the user
+// and admin can see it, and close it. The EHLO/HELO hostname is used as client
+// identification.
+func (c *conn) sessionStart() {
+	protocol := "submission"
+	if c.immediateTLS {
+		protocol = "submissions"
+	}
+	close := func() {
+		// Reads in the connection goroutine will fail and clean up.
+		err := c.origConn.Close()
+		c.log.Check(err, "closing connection for session")
+	}
+	var err error
+	c.sessionID, err = c.account.ProtocolSessionStart(context.TODO(), protocol, c.username, c.remoteIP, c.hello.String(), close)
+	c.log.Check(err, "recording session")
+}
+
+// sessionEnd marks the session as ended, if any.
+func (c *conn) sessionEnd() {
+	if c.sessionID == 0 {
+		return
+	}
+	err := c.account.ProtocolSessionEnd(context.TODO(), c.sessionID)
+	c.log.Check(err, "marking session as ended")
+	c.sessionID = 0
+}
+
 // for rset command, and a few more cases that reset the mail transaction state.
 // ../rfc/5321:2502
 func (c *conn) rset() {
@@ -389,6 +463,7 @@ func (c *conn) rset() {
 	c.smtputf8 = false
 	c.msgsmtputf8 = false
 	c.recipients = nil
+	c.secondaryMX = 0
 }
 
 func (c *conn) earliestDeadline(d time.Duration) time.Time {
@@ -572,7 +647,7 @@ func (c *conn) writelinef(format string, args ...any) {
 
 var cleanClose struct{} // Sentinel value for panic/recover indicating clean close of connection.
 
-func serve(listenerName string, cid int64, hostname dns.Domain, tlsConfig *tls.Config, nc net.Conn, resolver dns.Resolver, submission, tls bool, maxMessageSize int64, requireTLSForAuth, requireTLSForDelivery, requireTLS bool, dnsBLs []dns.Domain, firstTimeSenderDelay time.Duration) {
+func serve(listenerName string, cid int64, hostname dns.Domain, tlsConfig *tls.Config, nc net.Conn, resolver dns.Resolver, submission, tls bool, maxMessageSize int64, requireTLSForAuth, requireTLSForDelivery, requireTLS, noPlaintextAuth bool, dnsBLs []dns.Domain, firstTimeSenderDelay time.Duration) {
 	var localIP, remoteIP net.IP
 	if a, ok := nc.LocalAddr().(*net.TCPAddr); ok {
 		localIP = a.IP
@@ -593,15 +668,18 @@ func serve(listenerName string, cid int64, hostname dns.Domain, tlsConfig *tls.C
 		conn:                  nc,
 		submission:            submission,
 		tls:                   tls,
+		immediateTLS:          tls,
 		extRequireTLS:         requireTLS,
 		resolver:              resolver,
 		lastlog:               time.Now(),
 		tlsConfig:             tlsConfig,
 		localIP:               localIP,
 		remoteIP:              remoteIP,
+		listenerName:          listenerName,
 		hostname:              hostname,
 		maxMessageSize:        maxMessageSize,
 		requireTLSForAuth:     requireTLSForAuth,
+		noPlaintextAuth:       noPlaintextAuth,
 		requireTLSForDelivery: requireTLSForDelivery,
 		dnsBLs:                dnsBLs,
 		firstTimeSenderDelay:  firstTimeSenderDelay,
@@ -639,6 +717,7 @@ func serve(listenerName string, cid int64, hostname dns.Domain, tlsConfig *tls.C
 		c.conn.Close()     // If TLS, will try to write alert notification to already closed socket, returning error quickly.
 
 		if c.account != nil {
+			c.sessionEnd()
 			err := c.account.Close()
 			c.log.Check(err, "closing account")
 			c.account = nil
@@ -689,6 +768,13 @@ func serve(listenerName string, cid int64, hostname dns.Domain, tlsConfig *tls.C
 	mox.Connections.Register(nc, "smtp", listenerName)
 	defer mox.Connections.Unregister(nc)
 
+	if c.immediateTLS {
+		if err := c.immediateTLSHandshake(); err != nil {
+			c.log.Infox("tls handshake", err)
+			return
+		}
+	}
+
 	// ../rfc/5321:964 ../rfc/5321:4294 about announcing software and version
 	// Syntax: ../rfc/5321:2586
 	// We include the string ESMTP. https://cr.yp.to/smtp/greeting.html recommends it.
@@ -712,6 +798,22 @@ func serve(listenerName string, cid int64, hostname dns.Domain, tlsConfig *tls.C
 	}
 }
 
+// immediateTLSHandshake does the TLS handshake for connections that start with
+// TLS, before the greeting, so the fingerprint of the TLS client is known and
+// logged early.
+func (c *conn) immediateTLSHandshake() error {
+	tlsConn, ok := c.conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	cidctx := context.WithValue(mox.Context, mlog.CidKey, c.cid)
+	ctx, cancel := context.WithTimeout(cidctx, time.Minute)
+	defer cancel()
+	err := tlsConn.HandshakeContext(ctx)
+	c.tlsFingerprint = mox.TLSFingerprintLog(c.log, "submissions", tlsConn.NetConn())
+	return err
+}
+
 var commands = map[string]func(c *conn, p *parser){
 	"helo":     (*conn).cmdHelo,
 	"ehlo":     (*conn).cmdEhlo,
@@ -726,6 +828,7 @@ var commands = map[string]func(c *conn, p *parser){
 	"help":     (*conn).cmdHelp,
 	"noop":     (*conn).cmdNoop,
 	"quit":     (*conn).cmdQuit,
+	"xclient":  (*conn).cmdXclient,
 }
 
 func command(c *conn) {
@@ -784,6 +887,7 @@ func command(c *conn) {
 	fn, ok := commands[cmdl]
 	if !ok {
 		c.cmd = "(unknown)"
+		c.autoBanProtocol()
 		if c.ncmds == 0 {
 			// Other side is likely speaking something else than SMTP, send error message and
 			// stop processing because there is a good chance whatever they sent has multiple
@@ -795,6 +899,9 @@ func command(c *conn) {
 		xsmtpUserErrorf(smtp.C500BadSyntax, smtp.SeProto5BadCmdOrSeq1, "unknown command")
 	}
 	c.ncmds++
+	if c.sessionID != 0 {
+		c.account.ProtocolSessionActivity(c.sessionID)
+	}
 	fn(c, p)
 }
 
@@ -806,6 +913,14 @@ func (c *conn) kind() string {
 	return "smtp"
 }
 
+// autoBanProtocol counts protocol abuse towards an automatic ban. Not for incoming
+// delivery unless explicitly enabled, legitimate mail servers can make mistakes too.
+func (c *conn) autoBanProtocol() {
+	if c.submission || mox.AutoBanSMTPDelivery() {
+		mox.AutoBanFailure(c.log, c.remoteIP, mox.AutoBanProtocol)
+	}
+}
+
 func (c *conn) xneedHello() {
 	if c.hello.IsZero() {
 		xsmtpUserErrorf(smtp.C503BadCmdSeq, smtp.SeProto5BadCmdOrSeq1, "no ehlo/helo yet")
@@ -875,7 +990,10 @@ func (c *conn) cmdHello(p *parser, ehlo bool) {
 	c.rset()
 
 	c.ehlo = ehlo
-	c.hello = remote
+	// After XCLIENT, the hello of the original connection is kept.
+	if !c.xclient {
+		c.hello = remote
+	}
 
 	// https://www.iana.org/assignments/mail-parameters/mail-parameters.xhtml
 
@@ -899,7 +1017,19 @@ func (c *conn) cmdHello(p *parser, ehlo bool) {
 			// authentication. The client should select the bare variant when TLS isn't
 			// present, and also not indicate the server supports the PLUS variant in that
 			// case, or it would trigger the mechanism downgrade detection.
-			c.bwritelinef("250-AUTH SCRAM-SHA-256-PLUS SCRAM-SHA-256 SCRAM-SHA-1-PLUS SCRAM-SHA-1 CRAM-MD5 PLAIN LOGIN")
+			mechs := "SCRAM-SHA-512-PLUS SCRAM-SHA-512 SCRAM-SHA-256-PLUS SCRAM-SHA-256 SCRAM-SHA-1-PLUS SCRAM-SHA-1 CRAM-MD5"
+			if !c.noPlaintextAuth {
+				mechs += " PLAIN LOGIN"
+			}
+			if mox.Conf.Static.OIDC != nil {
+				// RFC 7628
+				mechs += " OAUTHBEARER XOAUTH2"
+			}
+			if c.tls && c.tlsConfig.ClientAuth != tls.NoClientCert {
+				// TLS client certificate, RFC 4422 appendix A.
+				mechs += " EXTERNAL"
+			}
+			c.bwritelinef("250-AUTH %s", mechs)
 		} else {
 			c.bwritelinef("250-AUTH ")
 		}
@@ -907,6 +1037,9 @@ func (c *conn) cmdHello(p *parser, ehlo bool) {
 		t := time.Now().Add(queue.FutureReleaseIntervalMax).UTC() // ../rfc/4865:98
 		c.bwritelinef("250-FUTURERELEASE %d %s", queue.FutureReleaseIntervalMax/time.Second, t.Format(time.RFC3339))
 	}
+	if c.xclientAllowed() {
+		c.bwritelinef("250-XCLIENT ADDR HELO")
+	}
 	c.bwritelinef("250-ENHANCEDSTATUSCODES") // ../rfc/2034:71
 	// todo future? c.writelinef("250-DSN")
 	c.bwritelinef("250-8BITMIME")                       // ../rfc/6152:86
@@ -927,6 +1060,9 @@ func (c *conn) cmdStarttls(p *parser) {
 	if c.account != nil {
 		xsmtpUserErrorf(smtp.C503BadCmdSeq, smtp.SeProto5BadCmdOrSeq1, "cannot starttls after authentication")
 	}
+	if c.xclient {
+		xsmtpUserErrorf(smtp.C503BadCmdSeq, smtp.SeProto5BadCmdOrSeq1, "cannot starttls after xclient")
+	}
 
 	// We don't want to do TLS on top of c.r because it also prints protocol traces: We
 	// don't want to log the TLS stream. So we'll do TLS on the underlying connection,
@@ -942,14 +1078,19 @@ func (c *conn) cmdStarttls(p *parser) {
 
 	// We add the cid to the output, to help debugging in case of a failing TLS connection.
 	c.writecodeline(smtp.C220ServiceReady, smtp.SeOther00, "go! ("+mox.ReceivedID(c.cid)+")", nil)
-	tlsConn := tls.Server(conn, c.tlsConfig)
+	fpConn := tlsfp.NewConn(conn)
+	tlsConn := tls.Server(fpConn, c.tlsConfig)
 	cidctx := context.WithValue(mox.Context, mlog.CidKey, c.cid)
 	ctx, cancel := context.WithTimeout(cidctx, time.Minute)
 	defer cancel()
 	c.log.Debug("starting tls server handshake")
-	if err := tlsConn.HandshakeContext(ctx); err != nil {
+	tlsStart := time.Now()
+	err := tlsConn.HandshakeContext(ctx)
+	c.tlsFingerprint = mox.TLSFingerprintLog(c.log, c.kind(), fpConn)
+	if err != nil {
 		panic(fmt.Errorf("starttls handshake: %s (%w)", err, errIO))
 	}
+	c.tlsHandshakeDuration = time.Since(tlsStart)
 	cancel()
 	tlsversion, ciphersuite := moxio.TLSInfo(tlsConn)
 	c.log.Debug("tls server handshake done", slog.String("tls", tlsversion), slog.String("ciphersuite", ciphersuite))
@@ -1007,10 +1148,13 @@ func (c *conn) cmdAuth(p *parser) {
 	authResult := "error"
 	defer func() {
 		metrics.AuthenticationInc("submission", authVariant, authResult)
+		metrics.AuthenticationListenerInc("submission", c.listenerName, authResult)
 		if authResult == "ok" {
 			mox.LimiterFailedAuth.Reset(c.remoteIP, time.Now())
+			c.sessionStart()
 		} else if !missingDerivedSecrets {
 			mox.LimiterFailedAuth.Add(c.remoteIP, time.Now(), 1)
+			mox.AutoBanFailure(c.log, c.remoteIP, mox.AutoBanAuth)
 		}
 	}()
 
@@ -1072,6 +1216,11 @@ func (c *conn) cmdAuth(p *parser) {
 	case "PLAIN":
 		authVariant = "plain"
 
+		if c.noPlaintextAuth {
+			// ../rfc/4954:176
+			xsmtpUserErrorf(smtp.C504ParamNotImpl, smtp.SeProto5BadParams4, "mechanism PLAIN disabled on this listener, use scram")
+		}
+
 		// ../rfc/4954:343
 		// ../rfc/4954:326
 		if !c.tls && c.requireTLSForAuth {
@@ -1095,7 +1244,7 @@ func (c *conn) cmdAuth(p *parser) {
 			xsmtpUserErrorf(smtp.C535AuthBadCreds, smtp.SePol7AuthBadCreds8, "cannot assume other role")
 		}
 
-		acc, err := store.OpenEmailAuth(c.log, authc, password)
+		acc, err := store.OpenEmailAuthProtocol(c.log, authc, password, "smtp", c.remoteIP)
 		if err != nil && errors.Is(err, store.ErrUnknownCredentials) {
 			// ../rfc/4954:274
 			authResult = "badcreds"
@@ -1119,6 +1268,11 @@ func (c *conn) cmdAuth(p *parser) {
 
 		authVariant = "login"
 
+		if c.noPlaintextAuth {
+			// ../rfc/4954:176
+			xsmtpUserErrorf(smtp.C504ParamNotImpl, smtp.SeProto5BadParams4, "mechanism LOGIN disabled on this listener, use scram")
+		}
+
 		// ../rfc/4954:343
 		// ../rfc/4954:326
 		if !c.tls && c.requireTLSForAuth {
@@ -1141,7 +1295,7 @@ func (c *conn) cmdAuth(p *parser) {
 		password := string(xreadContinuation())
 		c.xtrace(mlog.LevelTrace) // Restore.
 
-		acc, err := store.OpenEmailAuth(c.log, username, password)
+		acc, err := store.OpenEmailAuthProtocol(c.log, username, password, "smtp", c.remoteIP)
 		if err != nil && errors.Is(err, store.ErrUnknownCredentials) {
 			// ../rfc/4954:274
 			authResult = "badcreds"
@@ -1174,7 +1328,12 @@ func (c *conn) cmdAuth(p *parser) {
 		}
 		addr := norm.NFC.String(t[0])
 		c.log.Debug("cram-md5 auth", slog.String("address", addr))
-		acc, _, err := store.OpenEmail(c.log, addr)
+		if _, auth := store.ExternalAuth(addr); auth != nil {
+			// No locally stored secrets with external authentication.
+			c.log.Info("failed authentication attempt, cram-md5 not possible with external authentication", slog.String("username", addr), slog.Any("remote", c.remoteIP))
+			xsmtpUserErrorf(smtp.C535AuthBadCreds, smtp.SePol7AuthBadCreds8, "bad user/pass")
+		}
+		acc, _, err := store.OpenEmailLogin(c.log, addr)
 		if err != nil {
 			if errors.Is(err, store.ErrUnknownCredentials) {
 				c.log.Info("failed authentication attempt", slog.String("username", addr), slog.Any("remote", c.remoteIP))
@@ -1196,6 +1355,16 @@ func (c *conn) cmdAuth(p *parser) {
 					c.log.Info("failed authentication attempt", slog.String("username", addr), slog.Any("remote", c.remoteIP))
 					xsmtpUserErrorf(smtp.C535AuthBadCreds, smtp.SePol7AuthBadCreds8, "bad user/pass")
 				}
+				if err == nil {
+					// With two-factor authentication, only app passwords can be used, which don't
+					// have derived secrets for CRAM-MD5 and SCRAM.
+					if totpEnabled, err := store.TOTPEnabledTx(tx); err != nil {
+						return err
+					} else if totpEnabled {
+						c.log.Info("failed authentication attempt, two-factor authentication enabled, app password required", slog.String("username", addr), slog.Any("remote", c.remoteIP))
+						xsmtpUserErrorf(smtp.C535AuthBadCreds, smtp.SePol7AuthBadCreds8, "bad user/pass")
+					}
+				}
 				if err != nil {
 					return err
 				}
@@ -1231,7 +1400,7 @@ func (c *conn) cmdAuth(p *parser) {
 		// ../rfc/4954:276
 		c.writecodeline(smtp.C235AuthSuccess, smtp.SePol7Other0, "nice", nil)
 
-	case "SCRAM-SHA-256-PLUS", "SCRAM-SHA-256", "SCRAM-SHA-1-PLUS", "SCRAM-SHA-1":
+	case "SCRAM-SHA-512-PLUS", "SCRAM-SHA-512", "SCRAM-SHA-256-PLUS", "SCRAM-SHA-256", "SCRAM-SHA-1-PLUS", "SCRAM-SHA-1":
 		// todo: improve handling of errors during scram. e.g. invalid parameters. should we abort the imap command, or continue until the end and respond with a scram-level error?
 		// todo: use single implementation between ../imapserver/server.go and ../smtpserver/server.go
 
@@ -1244,6 +1413,8 @@ func (c *conn) cmdAuth(p *parser) {
 			h = sha1.New
 		case "scram-sha-256", "scram-sha-256-plus":
 			h = sha256.New
+		case "scram-sha-512", "scram-sha-512-plus":
+			h = sha512.New
 		default:
 			xsmtpServerErrorf(codes{smtp.C554TransactionFailed, smtp.SeSys3Other0}, "missing scram auth method case")
 		}
@@ -1263,7 +1434,12 @@ func (c *conn) cmdAuth(p *parser) {
 		xcheckf(err, "starting scram")
 		authc := norm.NFC.String(ss.Authentication)
 		c.log.Debug("scram auth", slog.String("authentication", authc))
-		acc, _, err := store.OpenEmail(c.log, authc)
+		if _, auth := store.ExternalAuth(authc); auth != nil {
+			// No locally stored secrets with external authentication.
+			c.log.Info("failed authentication attempt, scram not possible with external authentication", slog.String("username", authc), slog.Any("remote", c.remoteIP))
+			xsmtpUserErrorf(smtp.C454TempAuthFail, smtp.SeSys3Other0, "scram not possible")
+		}
+		acc, _, err := store.OpenEmailLogin(c.log, authc)
 		if err != nil {
 			// todo: we could continue scram with a generated salt, deterministically generated
 			// from the username. that way we don't have to store anything but attackers cannot
@@ -1288,12 +1464,24 @@ func (c *conn) cmdAuth(p *parser) {
 					c.log.Info("failed authentication attempt", slog.String("username", authc), slog.Any("remote", c.remoteIP))
 					xsmtpUserErrorf(smtp.C535AuthBadCreds, smtp.SePol7AuthBadCreds8, "bad user/pass")
 				}
+				if err == nil {
+					// With two-factor authentication, only app passwords can be used, which don't
+					// have derived secrets for CRAM-MD5 and SCRAM.
+					if totpEnabled, err := store.TOTPEnabledTx(tx); err != nil {
+						return err
+					} else if totpEnabled {
+						c.log.Info("failed authentication attempt, two-factor authentication enabled, app password required", slog.String("username", authc), slog.Any("remote", c.remoteIP))
+						xsmtpUserErrorf(smtp.C535AuthBadCreds, smtp.SePol7AuthBadCreds8, "bad user/pass")
+					}
+				}
 				xcheckf(err, "fetching credentials")
 				switch authVariant {
 				case "scram-sha-1", "scram-sha-1-plus":
 					xscram = password.SCRAMSHA1
 				case "scram-sha-256", "scram-sha-256-plus":
 					xscram = password.SCRAMSHA256
+				case "scram-sha-512", "scram-sha-512-plus":
+					xscram = password.SCRAMSHA512
 				default:
 					xsmtpServerErrorf(codes{smtp.C554TransactionFailed, smtp.SeSys3Other0}, "missing scram auth credentials case")
 				}
@@ -1338,6 +1526,88 @@ func (c *conn) cmdAuth(p *parser) {
 		// ../rfc/4954:276
 		c.writecodeline(smtp.C235AuthSuccess, smtp.SePol7Other0, "nice", nil)
 
+	case "OAUTHBEARER", "XOAUTH2":
+		authVariant = strings.ToLower(mech)
+
+		provider := oidc.Get(mox.Conf.Static.OIDC)
+		if provider == nil {
+			// ../rfc/4954:176
+			xsmtpUserErrorf(smtp.C504ParamNotImpl, smtp.SeProto5BadParams4, "mechanism %s not supported", mech)
+		}
+		// ../rfc/4954:343
+		// ../rfc/4954:326
+		if !c.tls && c.requireTLSForAuth {
+			xsmtpUserErrorf(smtp.C538EncReqForAuth, smtp.SePol7EncReqForAuth11, "authentication requires tls")
+		}
+
+		// Bearer tokens are credentials, so hide them.
+		defer c.xtrace(mlog.LevelTraceauth)()
+		buf := xreadInitial()
+		c.xtrace(mlog.LevelTrace) // Restore.
+		var username, token string
+		var err error
+		if authVariant == "oauthbearer" {
+			username, token, err = sasl.ParseOAuthBearer(buf)
+		} else {
+			username, token, err = sasl.ParseXOAuth2(buf)
+		}
+		if err != nil {
+			xsmtpUserErrorf(smtp.C501BadParamSyntax, smtp.SeProto5BadParams4, "%s", err)
+		}
+		username = norm.NFC.String(username)
+
+		acc, loginAddress, err := store.OpenEmailToken(c.log, token, username)
+		if err != nil && errors.Is(err, store.ErrUnknownCredentials) {
+			authResult = "badcreds"
+			c.log.Info("failed authentication attempt", slog.String("username", username), slog.Any("remote", c.remoteIP))
+			// The client must respond to the error details before we fail, RFC 7628
+			// section 3.2.3. XOAUTH2 works the same.
+			c.writelinef("%d %s", smtp.C334ContinueAuth, base64.StdEncoding.EncodeToString(sasl.OAuthErrorChallenge(provider.ConfigurationURL())))
+			c.readline()
+			// ../rfc/4954:274
+			xsmtpUserErrorf(smtp.C535AuthBadCreds, smtp.SePol7AuthBadCreds8, "bad credentials")
+		}
+		xcheckf(err, "verifying access token")
+
+		authResult = "ok"
+		c.authFailed = 0
+		c.setSlow(false)
+		c.account = acc
+		c.username = loginAddress
+		// ../rfc/4954:276
+		c.writecodeline(smtp.C235AuthSuccess, smtp.SePol7Other0, "nice", nil)
+
+	case "EXTERNAL":
+		authVariant = strings.ToLower(mech)
+
+		if !c.tls || c.tlsConfig.ClientAuth == tls.NoClientCert {
+			// ../rfc/4954:176
+			xsmtpUserErrorf(smtp.C504ParamNotImpl, smtp.SeProto5BadParams4, "mechanism %s not supported", mech)
+		}
+		authz := norm.NFC.String(string(xreadInitial()))
+		cs := c.conn.(*tls.Conn).ConnectionState()
+		if len(cs.VerifiedChains) == 0 {
+			authResult = "badcreds"
+			c.log.Info("failed authentication attempt, no client certificate", slog.String("username", authz), slog.Any("remote", c.remoteIP))
+			xsmtpUserErrorf(smtp.C535AuthBadCreds, smtp.SePol7AuthBadCreds8, "no client certificate")
+		}
+		acc, loginAddress, err := store.OpenTLSClientCert(c.log, cs.PeerCertificates[0], authz)
+		if err != nil && errors.Is(err, store.ErrUnknownCredentials) {
+			authResult = "badcreds"
+			c.log.Info("failed authentication attempt", slog.String("username", authz), slog.Any("remote", c.remoteIP))
+			// ../rfc/4954:274
+			xsmtpUserErrorf(smtp.C535AuthBadCreds, smtp.SePol7AuthBadCreds8, "bad credentials")
+		}
+		xcheckf(err, "verifying client certificate")
+
+		authResult = "ok"
+		c.authFailed = 0
+		c.setSlow(false)
+		c.account = acc
+		c.username = loginAddress
+		// ../rfc/4954:276
+		c.writecodeline(smtp.C235AuthSuccess, smtp.SePol7Other0, "nice", nil)
+
 	default:
 		// ../rfc/4954:176
 		xsmtpUserErrorf(smtp.C504ParamNotImpl, smtp.SeProto5BadParams4, "mechanism %s not supported", mech)
@@ -1354,6 +1624,7 @@ func (c *conn) cmdMail(p *parser) {
 		// If we get many bad transactions, it's probably a spammer that is guessing user names.
 		// Useful in combination with rate limiting.
 		// ../rfc/5321:4349
+		c.autoBanProtocol()
 		c.writecodeline(smtp.C550MailboxUnavail, smtp.SeAddr1Other0, "too many failures", nil)
 		panic(errIO)
 	}
@@ -1633,17 +1904,32 @@ func (c *conn) cmdRcpt(p *parser) {
 		c.xlocalserveError(fpath.Localpart)
 	}
 
+	// Rewrite the recipient address if configured, e.g. mapping a legacy domain onto
+	// a new domain. The message is delivered to the rewritten address.
+	if !fpath.IPDomain.Domain.IsZero() {
+		addr := smtp.NewAddress(fpath.Localpart, fpath.IPDomain.Domain)
+		if naddr, ok, err := mox.RewriteAddress(addr, false); err != nil {
+			c.log.Errorx("rewriting recipient address", err, slog.Any("rcptto", fpath))
+			xsmtpServerErrorf(codes{smtp.C451LocalErr, smtp.SeSys3Other0}, "error processing")
+		} else if ok {
+			c.log.Debug("rewrote recipient address", slog.Any("rcptto", fpath), slog.Any("rewritten", naddr))
+			fpath = naddr.Path()
+		}
+	}
+
 	if len(fpath.IPDomain.IP) > 0 {
 		if !c.submission {
 			xsmtpUserErrorf(smtp.C550MailboxUnavail, smtp.SeAddr1UnknownDestMailbox1, "not accepting email for ip")
 		}
-		c.recipients = append(c.recipients, recipient{fpath, nil, nil})
+		c.recipients = append(c.recipients, recipient{fpath, nil, nil, ""})
+	} else if trap, ok := mox.LookupSpamtrap(fpath.Localpart, fpath.IPDomain.Domain); ok && !c.submission {
+		c.recipients = append(c.recipients, recipient{fpath, nil, nil, trap})
 	} else if accountName, alias, canonical, addr, err := mox.LookupAddress(fpath.Localpart, fpath.IPDomain.Domain, true, true); err == nil {
 		// note: a bare postmaster, without domain, is handled by LookupAddress. ../rfc/5321:735
 		if alias != nil {
-			c.recipients = append(c.recipients, recipient{fpath, nil, &rcptAlias{*alias, canonical}})
+			c.recipients = append(c.recipients, recipient{fpath, nil, &rcptAlias{*alias, canonical}, ""})
 		} else {
-			c.recipients = append(c.recipients, recipient{fpath, &rcptAccount{accountName, addr, canonical}, nil})
+			c.recipients = append(c.recipients, recipient{fpath, &rcptAccount{accountName, addr, canonical}, nil, ""})
 		}
 
 	} else if Localserve {
@@ -1653,13 +1939,15 @@ func (c *conn) cmdRcpt(p *parser) {
 		// which is typically the mox user.
 		acc, _ := mox.Conf.Account("mox")
 		dest := acc.Destinations["mox@localhost"]
-		c.recipients = append(c.recipients, recipient{fpath, &rcptAccount{"mox", dest, "mox@localhost"}, nil})
+		c.recipients = append(c.recipients, recipient{fpath, &rcptAccount{"mox", dest, "mox@localhost"}, nil, ""})
+	} else if sd, ok := secondarymx.Domain(fpath.IPDomain.Domain); ok && errors.Is(err, mox.ErrDomainNotFound) && !c.submission {
+		c.xsecondaryMXRecipient(sd, fpath)
 	} else if errors.Is(err, mox.ErrDomainNotFound) {
 		if !c.submission {
 			xsmtpUserErrorf(smtp.C550MailboxUnavail, smtp.SeAddr1UnknownDestMailbox1, "not accepting email for domain")
 		}
 		// We'll be delivering this email.
-		c.recipients = append(c.recipients, recipient{fpath, nil, nil})
+		c.recipients = append(c.recipients, recipient{fpath, nil, nil, ""})
 	} else if errors.Is(err, mox.ErrAddressNotFound) {
 		if c.submission {
 			// For submission, we're transparent about which user exists. Should be fine for the typical small-scale deploy.
@@ -1669,11 +1957,17 @@ func (c *conn) cmdRcpt(p *parser) {
 		// We pretend to accept. We don't want to let remote know the user does not exist
 		// until after DATA. Because then remote has committed to sending a message.
 		// note: not local for !c.submission is the signal this address is in error.
-		c.recipients = append(c.recipients, recipient{fpath, nil, nil})
+		c.recipients = append(c.recipients, recipient{fpath, nil, nil, ""})
 	} else {
 		c.log.Errorx("looking up account for delivery", err, slog.Any("rcptto", fpath))
 		xsmtpServerErrorf(codes{smtp.C451LocalErr, smtp.SeSys3Other0}, "error processing")
 	}
+	if c.secondaryMX > 0 && c.secondaryMX != len(c.recipients) {
+		// Local recipient after recipients for secondary MX domains. The remote will try
+		// again in a new transaction.
+		c.recipients = c.recipients[:len(c.recipients)-1]
+		xsmtpUserErrorf(smtp.C452StorageFull, smtp.SeProto5TooManyRcpts3, "recipients for secondary mx domains must be in a separate transaction")
+	}
 	c.bwritecodeline(smtp.C250Completed, smtp.SeAddr1Other0, "now on the list", nil)
 }
 
@@ -1742,6 +2036,34 @@ func (c *conn) cmdData(p *parser) {
 	cidctx := context.WithValue(mox.Context, mlog.CidKey, c.cid)
 	cmdctx, cmdcancel := context.WithTimeout(cidctx, 30*time.Minute)
 	defer cmdcancel()
+
+	// The span for the transaction is the parent of spans for DNS lookups, junk
+	// analysis and, through the queue, outgoing deliveries.
+	cmdctx, span := tracing.StartKind(cmdctx, "smtp.receive", tracing.KindServer,
+		tracing.String("mox.cid", mox.ReceivedID(c.cid)),
+		tracing.String("smtp.listener", c.listenerName),
+		tracing.Bool("smtp.submission", c.submission),
+		tracing.String("net.peer.addr", c.remoteIP.String()),
+		tracing.Int("smtp.recipients", len(c.recipients)),
+		tracing.Bool("tls", c.tls),
+	)
+	if c.tls {
+		tlsversion, ciphersuite := moxio.TLSInfo(c.conn.(*tls.Conn))
+		span.SetAttrs(tracing.String("tls.version", tlsversion), tracing.String("tls.cipher", ciphersuite))
+		if c.tlsHandshakeDuration > 0 {
+			span.SetAttrs(tracing.Float64("tls.handshake_seconds", c.tlsHandshakeDuration.Seconds()))
+		}
+	}
+	defer func() {
+		x := recover()
+		if x != nil {
+			span.SetError(fmt.Errorf("%v", x))
+		}
+		span.End()
+		if x != nil {
+			panic(x)
+		}
+	}()
 	// Deadline is taken into account by Read and Write.
 	c.deadline, _ = cmdctx.Deadline()
 	defer func() {
@@ -1762,8 +2084,12 @@ func (c *conn) cmdData(p *parser) {
 	defer store.CloseRemoveTempFile(c.log, dataFile, "smtpserver delivered message")
 	msgWriter := message.NewWriter(dataFile)
 	dr := smtp.NewDataReader(c.r)
+	lineEndingConfig(dr)
 	n, err := io.Copy(&limitWriter{maxSize: c.maxMessageSize, w: msgWriter}, dr)
 	c.xtrace(mlog.LevelTrace) // Restore.
+	if err == nil || errors.Is(err, smtp.ErrCRLF) {
+		c.lineEndingAnomalies(cmdctx, dr)
+	}
 	if err != nil {
 		if errors.Is(err, errMessageTooLarge) {
 			// ../rfc/1870:136 and ../rfc/3463:382
@@ -1939,6 +2265,10 @@ func (c *conn) cmdData(p *parser) {
 	// internet traffic.
 	if c.submission {
 		c.submit(cmdctx, recvHdrFor, msgWriter, dataFile, part)
+	} else if c.secondaryMX > 0 {
+		c.forwardSecondaryMX(cmdctx, recvHdrFor, msgWriter, dataFile)
+	} else if n := mox.Conf.Static.Node; n != nil && n.Role == "frontend" {
+		c.forward(cmdctx, recvHdrFor, msgWriter, dataFile)
 	} else {
 		c.deliver(cmdctx, recvHdrFor, msgWriter, iprevStatus, iprevAuthentic, dataFile)
 	}
@@ -1962,6 +2292,21 @@ func hasTLSRequiredNo(h textproto.MIMEHeader) bool {
 }
 
 // submit is used for mail from authenticated users that we will try to deliver.
+// receivedEventDetail describes the SMTP transaction for the delivery history of
+// a received message.
+func (c *conn) receivedEventDetail(size int64) string {
+	s := fmt.Sprintf("ehlo %s, mail from %s, %d recipients, %d bytes", c.hello, c.mailFrom.XString(true), len(c.recipients), size)
+	if c.username != "" {
+		s += ", authenticated as " + c.username
+	}
+	if c.tls {
+		s += ", " + tls.VersionName(c.conn.(*tls.Conn).ConnectionState().Version)
+	} else {
+		s += ", plaintext"
+	}
+	return s
+}
+
 func (c *conn) submit(ctx context.Context, recvHdrFor func(string) string, msgWriter *message.Writer, dataFile *os.File, part *message.Part) {
 	// Similar between ../smtpserver/server.go:/submit\( and ../webmail/api.go:/MessageSubmit\( and ../webapisrv/server.go:/Send\(
 
@@ -1984,6 +2329,37 @@ func (c *conn) submit(ctx context.Context, recvHdrFor func(string) string, msgWr
 		xsmtpUserErrorf(smtp.C550MailboxUnavail, smtp.SePol7DeliveryUnauth1, "message from address must belong to authenticated user")
 	}
 
+	// Rewrite sender addresses if configured, e.g. to masquerade an internal domain.
+	// Done after checking the original address may be used, and before DKIM signing.
+	dataSize := msgWriter.Size
+	if nfrom, ok, err := mox.RewriteAddress(msgFrom, true); err != nil {
+		c.log.Errorx("rewriting message from address", err, slog.Any("msgfrom", msgFrom))
+		xsmtpServerErrorf(codes{smtp.C451LocalErr, smtp.SeSys3Other0}, "error rewriting message from address")
+	} else if ok {
+		nf, size, err := rewriteMsgFrom(c.log, dataFile, dataSize, part, nfrom, c.msgsmtputf8)
+		if err != nil {
+			c.log.Errorx("rewriting message from header", err)
+			metricServerErrors.WithLabelValues("rewrite").Inc()
+			xsmtpServerErrorf(codes{smtp.C451LocalErr, smtp.SeSys3Other0}, "internal error rewriting message from address")
+		}
+		defer store.CloseRemoveTempFile(c.log, nf, "message with rewritten from")
+		c.log.Debug("rewrote message from address", slog.Any("msgfrom", msgFrom), slog.Any("rewritten", nfrom))
+		dataFile = nf
+		dataSize = size
+		msgFrom = nfrom
+	}
+	if !c.mailFrom.IPDomain.Domain.IsZero() {
+		addr := smtp.NewAddress(c.mailFrom.Localpart, c.mailFrom.IPDomain.Domain)
+		if naddr, ok, err := mox.RewriteAddress(addr, true); err != nil {
+			c.log.Errorx("rewriting mail from address", err, slog.Any("mailfrom", c.mailFrom))
+			xsmtpServerErrorf(codes{smtp.C451LocalErr, smtp.SeSys3Other0}, "error rewriting mail from address")
+		} else if ok {
+			c.log.Debug("rewrote mail from address", slog.Any("mailfrom", c.mailFrom), slog.Any("rewritten", naddr))
+			np := naddr.Path()
+			c.mailFrom = &np
+		}
+	}
+
 	// TLS-Required: No header makes us not enforce recipient domain's TLS policy.
 	// ../rfc/8689:206
 	// Only when requiretls smtp extension wasn't used. ../rfc/8689:246
@@ -2014,11 +2390,11 @@ func (c *conn) submit(ctx context.Context, recvHdrFor func(string) string, msgWr
 	}
 
 	// Check outgoing message rate limit.
+	rcpts := make([]smtp.Path, len(c.recipients))
+	for i, r := range c.recipients {
+		rcpts[i] = r.addr
+	}
 	err = c.account.DB.Read(ctx, func(tx *bstore.Tx) error {
-		rcpts := make([]smtp.Path, len(c.recipients))
-		for i, r := range c.recipients {
-			rcpts[i] = r.addr
-		}
 		msglimit, rcptlimit, err := c.account.SendLimitReached(tx, rcpts)
 		xcheckf(err, "checking sender limit")
 		if msglimit >= 0 {
@@ -2032,6 +2408,15 @@ func (c *conn) submit(ctx context.Context, recvHdrFor func(string) string, msgWr
 	})
 	xcheckf(err, "read-only transaction")
 
+	// Check for signs of a compromised account.
+	if err := sendguard.Check(ctx, c.log, c.account, "smtp", c.remoteIP, rcpts, dataFile, dataSize); err != nil {
+		metricSubmission.WithLabelValues("guardrefused").Inc()
+		if errors.Is(err, sendguard.ErrFrozen) {
+			xsmtpUserErrorf(smtp.C550MailboxUnavail, smtp.SePol7AccountDisabled13, "%s", err)
+		}
+		xsmtpUserErrorf(smtp.C451LocalErr, smtp.SePol7DeliveryUnauth1, "%s", err)
+	}
+
 	// We gather any X-Mox-Extra-* headers into the "extra" data during queueing, which
 	// will make it into any webhook we deliver.
 	// todo: remove the X-Mox-Extra-* headers from the message. we don't currently rewrite the message...
@@ -2054,13 +2439,29 @@ func (c *conn) submit(ctx context.Context, recvHdrFor func(string) string, msgWr
 
 	// todo future: in a pedantic mode, we can parse the headers, and return an error if rcpt is only in To or Cc header, and not in the non-empty Bcc header. indicates a client that doesn't blind those bcc's.
 
-	// Add DKIM signatures.
 	confDom, ok := mox.Conf.Domain(msgFrom.Domain)
 	if !ok {
 		c.log.Error("domain disappeared", slog.Any("domain", msgFrom.Domain))
 		xsmtpServerErrorf(codes{smtp.C451LocalErr, smtp.SeSys3Other0}, "internal error")
 	}
 
+	// Add footer, e.g. a legal disclaimer, if configured. The message is rewritten to
+	// a new file.
+	accConf, _ := c.account.Conf()
+	if f := footer.Select(accConf, confDom); f != nil {
+		nf, size, err := footer.Apply(c.log, *f, dataFile)
+		if err != nil {
+			c.log.Errorx("adding footer to message", err)
+			metricServerErrors.WithLabelValues("footer").Inc()
+			xsmtpServerErrorf(codes{smtp.C451LocalErr, smtp.SeSys3Other0}, "internal error adding footer")
+		} else if nf != nil {
+			defer store.CloseRemoveTempFile(c.log, nf, "message with footer")
+			dataFile = nf
+			dataSize = size
+		}
+	}
+
+	// Add DKIM signatures.
 	selectors := mox.DKIMSelectors(confDom.DKIM)
 	if len(selectors) > 0 {
 		canonical := mox.CanonicalLocalpart(msgFrom.Localpart, confDom)
@@ -2092,7 +2493,6 @@ func (c *conn) submit(ctx context.Context, recvHdrFor func(string) string, msgWr
 	// measures. Accounts on a single mox instance should be allowed to block each
 	// other.
 
-	accConf, _ := c.account.Conf()
 	loginAddr, err := smtp.ParseAddress(c.username)
 	xcheckf(err, "parsing login address")
 	useFromID := slices.Contains(accConf.ParsedFromIDLoginAddresses, loginAddr)
@@ -2130,7 +2530,7 @@ func (c *conn) submit(ctx context.Context, recvHdrFor func(string) string, msgWr
 			rcptTo = rcpt.addr.String()
 		}
 		xmsgPrefix := append([]byte(recvHdrFor(rcptTo)), msgPrefix...)
-		msgSize := int64(len(xmsgPrefix)) + msgWriter.Size
+		msgSize := int64(len(xmsgPrefix)) + dataSize
 		qm := queue.MakeMsg(fp, rcpt.addr, msgWriter.Has8bit, c.msgsmtputf8, msgSize, messageID, xmsgPrefix, c.requireTLS, now, header.Get("Subject"))
 		if !c.futureRelease.IsZero() {
 			qm.NextAttempt = c.futureRelease
@@ -2141,6 +2541,14 @@ func (c *conn) submit(ctx context.Context, recvHdrFor func(string) string, msgWr
 		qml[i] = qm
 	}
 
+	admindb.MessageEventAdd(ctx, c.log, admindb.MessageEvent{
+		MessageID: messageID,
+		Kind:      admindb.EventReceived,
+		Account:   c.account.Name,
+		Remote:    c.remoteIP.String(),
+		Detail:    c.receivedEventDetail(dataSize),
+	})
+
 	// todo: it would be good to have a limit on messages (count and total size) a user has in the queue. also/especially with futurerelease. ../rfc/4865:387
 	if err := queue.Add(ctx, c.log, c.account.Name, dataFile, qml...); err != nil {
 		// Aborting the transaction is not great. But continuing and generating DSNs will
@@ -2326,7 +2734,11 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 				resolver = dns.MockResolver{TXT: txts}
 			}
 		}
+		dkimctx, span := tracing.Start(dkimctx, "dkim.verify")
 		dkimResults, dkimErr = dkim.Verify(dkimctx, c.log.Logger, resolver, c.msgsmtputf8, dkim.DefaultPolicy, dataFile, ignoreTestMode)
+		span.SetAttrs(tracing.Int("dkim.signatures", len(dkimResults)))
+		span.SetError(dkimErr)
+		span.End()
 		dkimcancel()
 	}()
 
@@ -2368,7 +2780,11 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 				}
 			}
 		}
+		spfctx, span := tracing.Start(spfctx, "spf.verify")
 		receivedSPF, spfDomain, spfExpl, spfAuthentic, spfErr = spf.Verify(spfctx, c.log.Logger, resolver, spfArgs)
+		span.SetAttrs(tracing.String("spf.result", string(receivedSPF.Result)))
+		span.SetError(spfErr)
+		span.End()
 		spfcancel()
 		if spfErr != nil {
 			c.log.Infox("spf verify", spfErr)
@@ -2381,7 +2797,7 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 	// Give immediate response if all recipients are unknown.
 	nunknown := 0
 	for _, r := range c.recipients {
-		if r.account == nil && r.alias == nil {
+		if r.account == nil && r.alias == nil && r.spamtrap == "" {
 			nunknown++
 		}
 	}
@@ -2602,6 +3018,18 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 	var messageID string
 	var parsedMessageID bool
 
+	// For the delivery history of the message.
+	var msgMessageID string
+	if envelope != nil {
+		msgMessageID = envelope.MessageID
+	}
+	admindb.MessageEventAdd(ctx, c.log, admindb.MessageEvent{
+		MessageID: msgMessageID,
+		Kind:      admindb.EventReceived,
+		Remote:    c.remoteIP.String(),
+		Detail:    c.receivedEventDetail(msgWriter.Size),
+	})
+
 	// We build up a DSN for each failed recipient. If we have recipients in dsnMsg
 	// after processing, we queue the DSN. Unless all recipients failed, in which case
 	// we may just fail the mail transaction instead (could be common for failure to
@@ -2624,9 +3052,17 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 			slog.Bool("usererror", userError),
 			slog.String("errmsg", errmsg))
 		deliverErrors = append(deliverErrors, e)
+		admindb.MessageEventAdd(ctx, c.log, admindb.MessageEvent{
+			MessageID: msgMessageID,
+			Kind:      admindb.EventRejected,
+			Recipient: rcpt.addr.String(),
+			Remote:    c.remoteIP.String(),
+			Result:    fmt.Sprintf("%d %s", code, secode),
+			Detail:    errmsg,
+		})
 	}
 
-	// Sort recipients: local accounts, aliases, unknown. For ensuring we don't deliver
+	// Sort recipients: local accounts, aliases, unknown and spamtraps. For ensuring we don't deliver
 	// to an alias destination that was also explicitly sent to.
 	rcptScore := func(r recipient) int {
 		if r.account != nil {
@@ -2654,6 +3090,18 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 		return false
 	}
 
+	// Content block lists are checked once for the message, when first needed during
+	// analysis of a recipient.
+	var contentResult *contentbl.Result
+	contentCheck := func() contentbl.Result {
+		if contentResult == nil {
+			cb := mox.Conf.Static.ContentBlocklists
+			r := contentbl.Check(ctx, c.log.Logger, c.resolver, cb.URIBLZones, cb.HashFiles, dataFile, msgWriter.Size)
+			contentResult = &r
+		}
+		return *contentResult
+	}
+
 	// Prepare a message, analyze it against account's junk filter.
 	// The returned analysis has an open account that must be closed by the caller.
 	// We call this for all alias destinations, also when we already delivered to that
@@ -2679,12 +3127,7 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 			RemoteIPMasked2:    ipmasked2,
 			RemoteIPMasked3:    ipmasked3,
 			EHLODomain:         c.hello.Domain.Name(),
-			MailFrom:           c.mailFrom.String(),
-			MailFromLocalpart:  c.mailFrom.Localpart,
 			MailFromDomain:     c.mailFrom.IPDomain.Domain.Name(),
-			RcptToLocalpart:    smtpRcptTo.Localpart,
-			RcptToDomain:       smtpRcptTo.IPDomain.Domain.Name(),
-			MsgFromLocalpart:   msgFrom.Localpart,
 			MsgFromDomain:      msgFrom.Domain.Name(),
 			MsgFromOrgDomain:   publicsuffix.Lookup(ctx, log.Logger, msgFrom.Domain).Name(),
 			EHLOValidated:      ehloValidation == store.ValidationPass,
@@ -2696,11 +3139,19 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 			DKIMDomains:        verifiedDKIMDomains,
 			DSN:                isDSN,
 			Size:               msgWriter.Size,
+			Sealed: store.MessageSealed{
+				MailFrom:          c.mailFrom.String(),
+				MailFromLocalpart: c.mailFrom.Localpart,
+				RcptToLocalpart:   smtpRcptTo.Localpart,
+				RcptToDomain:      smtpRcptTo.IPDomain.Domain.Name(),
+				MsgFromLocalpart:  msgFrom.Localpart,
+			},
 		}
 		if c.tls {
 			tlsState := c.conn.(*tls.Conn).ConnectionState()
 			m.ReceivedTLSVersion = tlsState.Version
 			m.ReceivedTLSCipherSuite = tlsState.CipherSuite
+			m.ReceivedTLSFingerprint = c.tlsFingerprint.JA4
 			if c.requireTLS != nil {
 				m.ReceivedRequireTLS = *c.requireTLS
 			}
@@ -2713,12 +3164,19 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 			msgTo = envelope.To
 			msgCc = envelope.CC
 		}
-		d := delivery{c.tls, &m, dataFile, smtpRcptTo, deliverTo, destination, canonicalAddr, acc, msgTo, msgCc, msgFrom, c.dnsBLs, dmarcUse, dmarcResult, dkimResults, iprevStatus}
+		m.PrepareAccount(acc.Name)
+		d := delivery{c.tls, &m, dataFile, smtpRcptTo, deliverTo, destination, canonicalAddr, acc, msgTo, msgCc, msgFrom, c.dnsBLs, dmarcUse, dmarcResult, dkimResults, iprevStatus, contentCheck, msgMessageID}
 
-		r := analyze(ctx, log, c.resolver, d)
+		actx, span := tracing.Start(ctx, "smtp.analyze", tracing.String("account", acc.Name))
+		r := analyze(actx, log, c.resolver, d)
+		span.SetAttrs(tracing.Bool("accept", r.accept), tracing.String("reason", r.reason))
+		span.End()
 		return &r, nil
 	}
 
+	var spamtrapTrained bool
+	var dmarcFailureReported bool
+
 	// Either deliver the message, or call addError to register the recipient as failed.
 	// If recipient is an alias, we may be delivering to multiple address/accounts and
 	// we will consider a message delivered if we delivered it to at least one account
@@ -2726,6 +3184,37 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 	processRecipient := func(rcpt recipient) {
 		log := c.log.With(slog.Any("mailfrom", c.mailFrom), slog.Any("rcptto", rcpt.addr))
 
+		// Messages for spamtraps are recorded, and the shared junk filter trained once
+		// per message, but never delivered.
+		if rcpt.spamtrap != "" {
+			h := admindb.SpamtrapHit{
+				Trap:          rcpt.spamtrap,
+				RemoteIP:      c.remoteIP.String(),
+				RemoteNetwork: ipmasked2,
+				EHLO:          c.hello.Domain.Name(),
+				MailFrom:      c.mailFrom.XString(true),
+				Domain:        spamtrapSenderDomain(ctx, log, msgFrom.Domain.Name(), msgFromValidation == store.ValidationStrict || msgFromValidation == store.ValidationDMARC || msgFromValidation == store.ValidationRelaxed, c.mailFrom.IPDomain.Domain.Name(), mailFromValidation == store.ValidationPass),
+				Size:          msgWriter.Size,
+			}
+			if !msgFrom.IsZero() {
+				h.MsgFrom = msgFrom.Pack(true)
+			}
+			if envelope != nil {
+				h.Subject = envelope.Subject
+			}
+			spamtrapRecord(ctx, log, h, !spamtrapTrained, dataFile)
+			admindb.MessageEventAdd(ctx, log, admindb.MessageEvent{
+				MessageID: msgMessageID,
+				Kind:      admindb.EventDiscarded,
+				Recipient: rcpt.addr.String(),
+				Remote:    c.remoteIP.String(),
+				Result:    "spamtrap",
+			})
+			spamtrapTrained = true
+			metricDelivery.WithLabelValues("spamtrap", "").Inc()
+			return
+		}
+
 		// If this is not a valid local user, we send back a DSN. This can only happen when
 		// there are also valid recipients, and only when remote is SPF-verified, so the DSN
 		// should not cause backscatter.
@@ -2754,13 +3243,19 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 		// any recipient accepts it. Regular destination have just a single account to
 		// check. We check all alias destinations, even if we already explicitly delivered
 		// to them: they may be the only destination that would accept the message.
-		var a0 *analysis // Analysis we've used for accept/reject decision.
+		var a0 *analysis  // Analysis we've used for accept/reject decision.
+		var moderate bool // Whether to hold the message for approval by the alias owner.
 		if rcpt.alias != nil {
 			// Check if msgFrom address is acceptable. This doesn't take validation into
 			// consideration. If the header was forged, the message may be rejected later on.
+			// For moderated aliases, messages from non-members that we would otherwise accept
+			// are held for approval.
 			if !aliasAllowedMsgFrom(rcpt.alias.alias, msgFrom) {
-				addError(rcpt, smtp.C550MailboxUnavail, smtp.SePol7ExpnProhibited2, true, "not allowed to send to destination")
-				return
+				if !rcpt.alias.alias.Moderated {
+					addError(rcpt, smtp.C550MailboxUnavail, smtp.SePol7ExpnProhibited2, true, "not allowed to send to destination")
+					return
+				}
+				moderate = true
 			}
 
 			la = make([]analysis, 0, len(rcpt.alias.alias.ParsedAddresses))
@@ -2798,6 +3293,13 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 			return
 		}
 
+		if !a0.accept && a0.reason == reasonDNSGreylisted {
+			log.Info("incoming message greylisted, not storing in rejects mailbox", slog.Any("msgfrom", msgFrom))
+			metricDelivery.WithLabelValues("greylist", a0.reason).Inc()
+			addError(rcpt, a0.code, a0.secode, a0.userError, a0.errmsg)
+			return
+		}
+
 		// Any DMARC result override is stored in the evaluation for outgoing DMARC
 		// aggregate reports, and added to the Authentication-Results message header.
 		// We want to tell the sender that we have an override, e.g. for mailing lists, so
@@ -2835,7 +3337,7 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 		for i := range la {
 			// ../rfc/5321:3204
 			// Received-SPF header goes before Received. ../rfc/7208:2038
-			la[i].d.m.MsgPrefix = []byte(
+			la[i].d.m.Sealed.MsgPrefix = []byte(
 				xmox +
 					"Delivered-To: " + la[i].d.deliverTo.XString(c.msgsmtputf8) + "\r\n" + // ../rfc/9228:274
 					"Return-Path: <" + c.mailFrom.String() + ">\r\n" + // ../rfc/5321:3300
@@ -2843,7 +3345,7 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 					receivedSPF.Header() +
 					recvHdrFor(rcpt.addr.String()),
 			)
-			la[i].d.m.Size += int64(len(la[i].d.m.MsgPrefix))
+			la[i].d.m.Size += int64(len(la[i].d.m.Sealed.MsgPrefix))
 		}
 
 		// Store DMARC evaluation for inclusion in an aggregate report. Only if there is at
@@ -2928,7 +3430,7 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 					Policy:          dmarcrpt.Disposition(r.Policy),
 					SubdomainPolicy: sp,
 					Percentage:      r.Percentage,
-					// We don't save ReportingOptions, we don't do per-message failure reporting.
+					// We don't save ReportingOptions, failure reports are sent for individual messages during delivery.
 				},
 				SourceIP:        c.remoteIP.String(),
 				Disposition:     disposition,
@@ -2979,6 +3481,60 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 			log.Check(err, "adding dmarc evaluation to database for aggregate report")
 		}
 
+		// Send a failure report about messages failing DMARC checks, once per message,
+		// under the same conditions as for aggregate reports.
+		if !dmarcFailureReported && !mox.Conf.Static.NoOutgoingDMARCReports && dmarcResult.Record != nil && len(dmarcResult.Record.FailureReportAddresses) > 0 && (a0.accept && !a0.d.m.IsReject || a0.reason == reasonDMARCPolicy) {
+			dmarcFailureReported = true
+			deliveryResult := "delivered"
+			if !a0.accept {
+				deliveryResult = "reject"
+			} else if a0.d.m.Junk {
+				deliveryResult = "spam"
+			}
+			dmarcFailureReport(log, c.resolver, dmarcResult, dkimResults, receivedSPF.Result, rcptAuthResults, c.remoteIP, *c.mailFrom, a0.d.m.Received, deliveryResult, dataFile)
+		}
+
+		// Instead of rejecting, we may hold the message in quarantine for review. The
+		// message is accepted, so the remote will not retry.
+		if !a0.accept && quarantine.Holds(a0.reason) {
+			var nheld int
+			for _, a := range la {
+				// Don't add message if address was also explicitly present in a RCPT TO command.
+				if rcpt.alias != nil && regularRecipient(a.d.deliverTo) {
+					continue
+				}
+
+				qm := admindb.Quarantined{
+					Received: a.d.m.Received,
+					Account:  a.d.acc.Name,
+					Mailbox:  a.d.destination.Mailbox,
+					Reason:   a0.reason,
+					RemoteIP: a.d.m.RemoteIP,
+					MailFrom: a.d.m.Sealed.MailFrom,
+					RcptTo:   rcpt.addr.String(),
+					Size:     a.d.m.Size,
+				}
+				if !msgFrom.IsZero() {
+					qm.MsgFrom = msgFrom.String()
+				}
+				if envelope != nil {
+					qm.Subject = envelope.Subject
+					qm.MessageID = envelope.MessageID
+				}
+				if err := quarantine.Add(ctx, log, &qm, store.FileMsgReader(a.d.m.Sealed.MsgPrefix, dataFile)); err != nil {
+					log.Errorx("adding message to quarantine", err)
+					continue
+				}
+				nheld++
+				a.d.event(ctx, log, admindb.EventQuarantined, a0.reason, "")
+			}
+			if nheld > 0 {
+				log.Info("incoming message quarantined", slog.String("reason", a0.reason), slog.Any("msgfrom", msgFrom))
+				metricDelivery.WithLabelValues("quarantined", a0.reason).Inc()
+				return
+			}
+		}
+
 		if !a0.accept {
 			for _, a := range la {
 				// Don't add message if address was also explicitly present in a RCPT TO command.
@@ -3031,6 +3587,37 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 			return
 		}
 
+		if moderate {
+			prefix := xmox +
+				"Return-Path: <" + c.mailFrom.String() + ">\r\n" +
+				rcptAuthResults.Header() +
+				receivedSPF.Header() +
+				recvHdrFor(rcpt.addr.String())
+			h := admindb.ModerationHeld{
+				Received: a0.d.m.Received,
+				Alias:    rcpt.alias.canonicalAddress,
+				RemoteIP: a0.d.m.RemoteIP,
+				MailFrom: a0.d.m.Sealed.MailFrom,
+				Size:     int64(len(prefix)) + msgWriter.Size,
+				Has8bit:  msgWriter.Has8bit,
+				SMTPUTF8: c.msgsmtputf8,
+			}
+			if !msgFrom.IsZero() {
+				h.MsgFrom = msgFrom.String()
+			}
+			if envelope != nil {
+				h.Subject = envelope.Subject
+				h.MessageID = envelope.MessageID
+			}
+			if err := moderation.Hold(ctx, log, rcpt.alias.alias, &h, store.FileMsgReader([]byte(prefix), dataFile)); err != nil {
+				log.Errorx("holding message for moderated alias", err)
+				addError(rcpt, smtp.C451LocalErr, smtp.SeSys3Other0, false, "error processing")
+				return
+			}
+			metricDelivery.WithLabelValues("moderated", a0.reason).Inc()
+			return
+		}
+
 		delayFirstTime := true
 		if rcpt.account != nil && a0.dmarcReport != nil {
 			// todo future: add rate limiting to prevent DoS attacks. ../rfc/7489:2570
@@ -3052,6 +3639,11 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 				delayFirstTime = false
 			}
 		}
+		if rcpt.account != nil && a0.abuseReport != nil {
+			// Not marked as seen, abuse reports may need attention.
+			abuseReportProcess(ctx, log, rcpt.addr.String(), msgFrom, a0.abuseReport)
+			delayFirstTime = false
+		}
 
 		// If this is a first-time sender and not a forwarded/mailing list message, wait
 		// before actually delivering. If this turns out to be a spammer, we've kept one of
@@ -3078,7 +3670,7 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 
 		// Gather the message-id before we deliver and the file may be consumed.
 		if !parsedMessageID {
-			if p, err := message.Parse(c.log.Logger, false, store.FileMsgReader(a0.d.m.MsgPrefix, dataFile)); err != nil {
+			if p, err := a0.d.m.EnsureParsed(log, dataFile); err != nil {
 				log.Infox("parsing message for message-id", err)
 			} else if header, err := p.Header(); err != nil {
 				log.Infox("parsing message header for message-id", err)
@@ -3099,40 +3691,67 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 				continue
 			}
 
+			// Apply the filter rules configured by the user in webmail.
+			mailbox := a.mailbox
+			rule := a.d.acc.FilterRuleMatch(log, a.d.m, dataFile)
+			if rule != nil {
+				log.Debug("filter rule matched", slog.Int64("ruleid", rule.ID), slog.String("rule", rule.Name))
+				if rule.ForwardTo != "" {
+					forwardFiltered(ctx, log, a.d, rule.ForwardTo, msgWriter, c.msgsmtputf8, messageID, envelope, dataFile)
+				}
+				if rule.Discard {
+					ndelivered++
+					metricDelivery.WithLabelValues("discarded", a0.reason).Inc()
+					log.Info("incoming message discarded by filter rule", slog.Int64("ruleid", rule.ID), slog.Any("msgfrom", msgFrom))
+					a.d.event(ctx, log, admindb.EventDiscarded, "filter rule", fmt.Sprintf("rule %d %q", rule.ID, rule.Name))
+					continue
+				}
+				if rule.Mailbox != "" {
+					mailbox = rule.Mailbox
+				}
+				a.d.m.Seen = a.d.m.Seen || rule.Seen
+				a.d.m.Flagged = a.d.m.Flagged || rule.Flagged
+				a.d.m.Keywords, _ = store.MergeKeywords(a.d.m.Keywords, rule.Keywords)
+			}
+
+			// Concurrent deliveries to the account are committed together.
 			var delivered bool
-			a.d.acc.WithWLock(func() {
-				if err := a.d.acc.DeliverMailbox(log, a.mailbox, a.d.m, dataFile); err != nil {
-					log.Errorx("delivering", err)
-					metricDelivery.WithLabelValues("delivererror", a0.reason).Inc()
-					if errors.Is(err, store.ErrOverQuota) {
-						nfull++
-					} else {
-						addError(rcpt, smtp.C451LocalErr, smtp.SeSys3Other0, false, "error processing")
-						nerr++
-					}
-					return
+			if err := a.d.acc.DeliverGroup(log, mailbox, a.d.m, dataFile); err != nil {
+				log.Errorx("delivering", err)
+				metricDelivery.WithLabelValues("delivererror", a0.reason).Inc()
+				if errors.Is(err, store.ErrOverQuota) {
+					nfull++
+				} else {
+					addError(rcpt, smtp.C451LocalErr, smtp.SeSys3Other0, false, "error processing")
+					nerr++
 				}
+			} else {
 				delivered = true
 				ndelivered++
 				metricDelivery.WithLabelValues("delivered", a0.reason).Inc()
-				log.Info("incoming message delivered", slog.String("reason", a0.reason), slog.Any("msgfrom", msgFrom))
+				log.Info("incoming message delivered", slog.String("reason", a0.reason), slog.Any("msgfrom", msgFrom), slog.String("messageid", messageID))
 
 				conf, _ := a.d.acc.Conf()
 				if conf.RejectsMailbox != "" && a.d.m.MessageID != "" {
-					if err := a.d.acc.RejectsRemove(log, conf.RejectsMailbox, a.d.m.MessageID); err != nil {
-						log.Errorx("removing message from rejects mailbox", err, slog.String("messageid", messageID))
-					}
+					a.d.acc.WithWLock(func() {
+						if err := a.d.acc.RejectsRemove(log, conf.RejectsMailbox, a.d.m.MessageID); err != nil {
+							log.Errorx("removing message from rejects mailbox", err, slog.String("messageid", messageID))
+						}
+					})
 				}
-			})
+			}
 
 			// Pass delivered messages to queue for DSN processing and/or hooks.
 			if delivered {
-				mr := store.FileMsgReader(a.d.m.MsgPrefix, dataFile)
+				a.d.event(ctx, log, admindb.EventDelivered, a0.reason, "mailbox "+mailbox)
+				accounting.Received(ctx, log, a.d.acc.Name, a.d.deliverTo.IPDomain.Domain, a.d.m.Size)
+
+				mr := store.FileMsgReader(a.d.m.Sealed.MsgPrefix, dataFile)
 				part, err := a.d.m.LoadPart(mr)
 				if err != nil {
 					log.Errorx("loading parsed part for evaluating webhook", err)
 				} else {
-					err = queue.Incoming(context.Background(), log, a.d.acc, messageID, *a.d.m, part, a.mailbox)
+					err = queue.Incoming(context.Background(), log, a.d.acc, messageID, *a.d.m, part, mailbox)
 					log.Check(err, "queueing webhook for incoming delivery")
 				}
 			} else if nerr > 0 && ndelivered == 0 {
@@ -3141,6 +3760,24 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 				break
 			}
 		}
+		// Queue the message for external members of an alias, unless the delivery to the
+		// local members failed, in which case the remote will retry.
+		if rcpt.alias != nil && (ndelivered > 0 || nerr == 0 && nfull == 0) {
+			prefix := []byte(
+				"Delivered-To: " + rcpt.addr.XString(c.msgsmtputf8) + "\r\n" +
+					rcptAuthResults.Header() +
+					receivedSPF.Header() +
+					recvHdrFor(rcpt.addr.String()),
+			)
+			var subject string
+			if envelope != nil {
+				subject = envelope.Subject
+			}
+			if err := moderation.ForwardExternal(ctx, log, rcpt.alias.alias, msgFrom, msgWriter.Has8bit, c.msgsmtputf8, int64(len(prefix))+msgWriter.Size, messageID, subject, prefix, dataFile); err != nil {
+				log.Errorx("queueing message for external alias members", err)
+			}
+		}
+
 		if ndelivered == 0 && (nerr > 0 || nfull > 0) {
 			if nerr == 0 {
 				addError(rcpt, smtp.C452StorageFull, smtp.SeMailbox2Full2, true, "account storage full")
@@ -3188,7 +3825,7 @@ func (c *conn) deliver(ctx context.Context, recvHdrFor func(string) string, msgW
 			code = smtp.C554TransactionFailed
 		}
 		lines = append(lines, "multiple errors")
-		xsmtpErrorf(code, secode, !serverError, strings.Join(lines, "\n"))
+		xsmtpErrorf(code, secode, !serverError, "%s", strings.Join(lines, "\n"))
 	}
 	// Generate one DSN for all failed recipients.
 	if len(deliverErrors) > 0 {
@@ -3253,6 +3890,9 @@ func aliasAllowedMsgFrom(alias config.Alias, msgFrom smtp.Address) bool {
 			return true
 		}
 	}
+	if slices.Contains(alias.ExternalAddresses, msgFrom) {
+		return true
+	}
 	lp, err := smtp.ParseLocalpart(alias.LocalpartStr)
 	xcheckf(err, "parsing alias localpart")
 	if msgFrom == smtp.NewAddress(lp, alias.Domain) {
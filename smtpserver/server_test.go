@@ -4,21 +4,25 @@ package smtpserver
 // todo: test delivering a message to multiple recipients, and with some of them failing.
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"crypto/ed25519"
 	cryptorand "crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"math/big"
 	"mime/quotedprintable"
 	"net"
 	"os"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
 	"testing"
@@ -26,12 +30,15 @@ import (
 
 	"github.com/mjl-/bstore"
 
+	"github.com/mjl-/mox/admindb"
 	"github.com/mjl-/mox/config"
 	"github.com/mjl-/mox/dkim"
 	"github.com/mjl-/mox/dmarcdb"
 	"github.com/mjl-/mox/dns"
 	"github.com/mjl-/mox/mlog"
 	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/oidc/oidctest"
+	"github.com/mjl-/mox/quarantine"
 	"github.com/mjl-/mox/queue"
 	"github.com/mjl-/mox/sasl"
 	"github.com/mjl-/mox/smtp"
@@ -82,19 +89,22 @@ test email, unique.
 `, "\n", "\r\n")
 
 type testserver struct {
-	t          *testing.T
-	acc        *store.Account
-	switchStop func()
-	comm       *store.Comm
-	cid        int64
-	resolver   dns.Resolver
-	auth       func(mechanisms []string, cs *tls.ConnectionState) (sasl.Client, error)
-	user, pass string
-	submission bool
-	requiretls bool
-	dnsbls     []dns.Domain
-	tlsmode    smtpclient.TLSMode
-	tlspkix    bool
+	t               *testing.T
+	acc             *store.Account
+	switchStop      func()
+	comm            *store.Comm
+	cid             int64
+	resolver        dns.Resolver
+	auth            func(mechanisms []string, cs *tls.ConnectionState) (sasl.Client, error)
+	user, pass      string
+	submission      bool
+	requiretls      bool
+	noPlaintextAuth bool
+	dnsbls          []dns.Domain
+	tlsmode         smtpclient.TLSMode
+	tlspkix         bool
+	xclient         *smtpclient.XClient
+	serverTLSConfig *tls.Config // If set, instead of config with fake certificate.
 }
 
 const password0 = "te\u0301st \u00a0\u2002\u200a" // NFD and various unicode spaces.
@@ -179,6 +189,7 @@ func (ts *testserver) run(fn func(helloErr error, client *smtpclient.Client)) {
 		opts := smtpclient.Opts{
 			Auth:    auth,
 			RootCAs: mox.Conf.Static.TLS.CertPool,
+			XClient: ts.xclient,
 		}
 		log := pkglog.WithCid(ts.cid - 1)
 		client, err := smtpclient.New(ctxbg, log.Logger, conn, ts.tlsmode, ts.tlspkix, ourHostname, remoteHostname, opts)
@@ -203,10 +214,13 @@ func (ts *testserver) runRaw(fn func(clientConn net.Conn)) {
 	defer func() { <-serverdone }()
 
 	go func() {
-		tlsConfig := &tls.Config{
-			Certificates: []tls.Certificate{fakeCert(ts.t)},
+		tlsConfig := ts.serverTLSConfig
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{
+				Certificates: []tls.Certificate{fakeCert(ts.t)},
+			}
 		}
-		serve("test", ts.cid-2, dns.Domain{ASCII: "mox.example"}, tlsConfig, serverConn, ts.resolver, ts.submission, false, 100<<20, false, false, ts.requiretls, ts.dnsbls, 0)
+		serve("test", ts.cid-2, dns.Domain{ASCII: "mox.example"}, tlsConfig, serverConn, ts.resolver, ts.submission, false, 100<<20, false, false, ts.requiretls, ts.noPlaintextAuth, ts.dnsbls, 0)
 		close(serverdone)
 	}()
 
@@ -316,6 +330,12 @@ func TestSubmission(t *testing.T) {
 		func(user, pass string, cs *tls.ConnectionState) sasl.Client {
 			return sasl.NewClientSCRAMSHA256PLUS(user, pass, *cs)
 		},
+		func(user, pass string, cs *tls.ConnectionState) sasl.Client {
+			return sasl.NewClientSCRAMSHA512(user, pass, false)
+		},
+		func(user, pass string, cs *tls.ConnectionState) sasl.Client {
+			return sasl.NewClientSCRAMSHA512PLUS(user, pass, *cs)
+		},
 	}
 	for _, fn := range authfns {
 		testAuth(fn, "mjl@mox.example", "test", &smtpclient.Error{Code: smtp.C535AuthBadCreds, Secode: smtp.SePol7AuthBadCreds8})           // Bad (short) password.
@@ -327,6 +347,37 @@ func TestSubmission(t *testing.T) {
 		testAuth(fn, "mo\u0301x@mox.example", password0, nil)
 		testAuth(fn, "mo\u0301x@mox.example", password1, nil)
 	}
+
+	// With plain text authentication disabled for the listener, PLAIN is rejected, but
+	// SCRAM still works.
+	ts.noPlaintextAuth = true
+	testAuth(authfns[0], "mjl@mox.example", password0, &smtpclient.Error{Code: smtp.C504ParamNotImpl, Secode: smtp.SeProto5BadParams4})
+	testAuth(authfns[len(authfns)-1], "mjl@mox.example", password0, nil)
+	ts.noPlaintextAuth = false
+
+	// OAuth 2.0 bearer tokens from an identity provider. The pass parameter is the
+	// token.
+	idp := oidctest.NewIDP()
+	defer idp.Close()
+	oauthfns := []func(user, pass string, cs *tls.ConnectionState) sasl.Client{
+		func(user, pass string, cs *tls.ConnectionState) sasl.Client {
+			return sasl.NewClientOAuthBearer(user, pass)
+		},
+		func(user, pass string, cs *tls.ConnectionState) sasl.Client { return sasl.NewClientXOAuth2(user, pass) },
+	}
+	// Not supported without OIDC configuration.
+	testAuth(oauthfns[0], "mjl@mox.example", idp.AccessToken("imap", "mjl@mox.example"), &smtpclient.Error{Code: smtp.C504ParamNotImpl, Secode: smtp.SeProto5BadParams4})
+	mox.Conf.Static.OIDC = &config.OIDC{Issuer: idp.Server.URL, ClientID: "mox", Audiences: []string{"imap"}}
+	defer func() { mox.Conf.Static.OIDC = nil }()
+	for _, fn := range oauthfns {
+		// Failures get a continuation with error details, our client fails on it.
+		badToken := &smtpclient.Error{Code: smtp.C334ContinueAuth}
+		testAuth(fn, "mjl@mox.example", "bogus", badToken)
+		testAuth(fn, "mjl@mox.example", idp.AccessToken("other", "mjl@mox.example"), badToken)
+		testAuth(fn, "other@mox.example", idp.AccessToken("imap", "mjl@mox.example"), badToken)
+		testAuth(fn, "mjl@mox.example", idp.AccessToken("imap", "mjl@mox.example"), nil)
+		testAuth(fn, "móx@mox.example", idp.AccessToken("imap", "móx@mox.example"), nil)
+	}
 }
 
 // Test delivery from external MTA.
@@ -445,6 +496,56 @@ func TestDelivery(t *testing.T) {
 	checkEvaluationCount(t, 0)
 }
 
+// Test the delivery history of incoming messages is recorded.
+func TestDeliveryEvents(t *testing.T) {
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"example.org.": {"127.0.0.10"}, // For mx check.
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"example.org."}, // For iprev, to get delivery accepted.
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), resolver)
+	defer ts.close()
+	admindb.Close()
+	err := admindb.Init()
+	tcheck(t, err, "admindb init")
+	defer admindb.Close()
+
+	ts.run(func(err error, client *smtpclient.Client) {
+		if err == nil {
+			err = client.Deliver(ctxbg, "remote@example.org", "mjl@mox.example", int64(len(deliverMessage)), strings.NewReader(deliverMessage), false, false, false)
+		}
+		tcheck(t, err, "deliver")
+	})
+
+	events, err := admindb.MessageEventList(ctxbg, "<test@example.org>")
+	tcheck(t, err, "list message events")
+	var kinds []admindb.EventKind
+	var received, delivered int
+	for _, e := range events {
+		kinds = append(kinds, e.Kind)
+		if e.MessageID != "test@example.org" || e.Remote != "127.0.0.10" {
+			t.Fatalf("event with message-id %q and remote %q, expected test@example.org and 127.0.0.10", e.MessageID, e.Remote)
+		}
+		switch e.Kind {
+		case admindb.EventReceived:
+			received++
+		case admindb.EventDelivered:
+			delivered++
+			if e.Account != "mjl" || e.Recipient != "mjl@mox.example" || e.Detail != "mailbox Inbox" || e.Result == "" {
+				t.Fatalf("bad delivered event %#v", e)
+			}
+		case admindb.EventRejected:
+			t.Fatalf("unexpected rejected event %#v", e)
+		}
+	}
+	if received != 1 || delivered != 1 || kinds[0] != admindb.EventReceived || kinds[len(kinds)-1] != admindb.EventDelivered {
+		t.Fatalf("got events %v, expected received first and delivered last", kinds)
+	}
+}
+
 func tinsertmsg(t *testing.T, acc *store.Account, mailbox string, m *store.Message, msg string) {
 	mf, err := store.CreateMessageTemp(pkglog, "queue-dsn")
 	tcheck(t, err, "temp message")
@@ -486,7 +587,7 @@ func tretrain(t *testing.T, acc *store.Account) {
 
 		f, err := os.Open(acc.MessagePath(m.ID))
 		tcheck(t, err, "open message")
-		r := store.FileMsgReader(m.MsgPrefix, f)
+		r := store.FileMsgReader(m.Sealed.MsgPrefix, f)
 
 		jf.TrainMessage(ctxbg, r, m.Size, ham)
 
@@ -518,18 +619,20 @@ func TestSpam(t *testing.T) {
 		RemoteIPMasked1:   "127.0.0.10",
 		RemoteIPMasked2:   "127.0.0.0",
 		RemoteIPMasked3:   "127.0.0.0",
-		MailFrom:          "remote@example.org",
-		MailFromLocalpart: smtp.Localpart("remote"),
 		MailFromDomain:    "example.org",
-		RcptToLocalpart:   smtp.Localpart("mjl"),
-		RcptToDomain:      "mox.example",
-		MsgFromLocalpart:  smtp.Localpart("remote"),
 		MsgFromDomain:     "example.org",
 		MsgFromOrgDomain:  "example.org",
 		MsgFromValidated:  true,
 		MsgFromValidation: store.ValidationStrict,
 		Flags:             store.Flags{Seen: true, Junk: true},
 		Size:              int64(len(deliverMessage)),
+		Sealed: store.MessageSealed{
+			MailFrom:          "remote@example.org",
+			MailFromLocalpart: smtp.Localpart("remote"),
+			RcptToLocalpart:   smtp.Localpart("mjl"),
+			RcptToDomain:      "mox.example",
+			MsgFromLocalpart:  smtp.Localpart("remote"),
+		},
 	}
 	for i := 0; i < 3; i++ {
 		nm := m
@@ -788,11 +891,13 @@ func TestDMARCSent(t *testing.T) {
 
 	// Insert spammy messages not related to the test message.
 	m := store.Message{
-		MailFrom:        "remote@test.example",
-		RcptToLocalpart: smtp.Localpart("mjl"),
-		RcptToDomain:    "mox.example",
-		Flags:           store.Flags{Seen: true, Junk: true},
-		Size:            int64(len(deliverMessage)),
+		Flags: store.Flags{Seen: true, Junk: true},
+		Size:  int64(len(deliverMessage)),
+		Sealed: store.MessageSealed{
+			MailFrom:        "remote@test.example",
+			RcptToLocalpart: smtp.Localpart("mjl"),
+			RcptToDomain:    "mox.example",
+		},
 	}
 	for i := 0; i < 3; i++ {
 		nm := m
@@ -922,6 +1027,574 @@ func TestBlocklistedSubjectpass(t *testing.T) {
 	})
 }
 
+// Test holding a message in quarantine instead of rejecting it.
+func TestQuarantine(t *testing.T) {
+	resolver := &dns.MockResolver{
+		A: map[string][]string{
+			"example.org.":              {"127.0.0.10"}, // For mx check.
+			"2.0.0.127.dnsbl.example.":  {"127.0.0.2"},  // For healthcheck.
+			"10.0.0.127.dnsbl.example.": {"127.0.0.10"}, // Where our connection pretends to come from.
+		},
+		TXT: map[string][]string{
+			"10.0.0.127.dnsbl.example.": {"blocklisted"},
+			"example.org.":              {"v=spf1 ip4:127.0.0.10 -all"},
+			"_dmarc.example.org.":       {"v=DMARC1;p=reject"},
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"example.org."}, // For iprev check.
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), resolver)
+	ts.dnsbls = []dns.Domain{{ASCII: "dnsbl.example"}}
+	defer ts.close()
+	err := admindb.Init()
+	tcheck(t, err, "admindb init")
+	defer admindb.Close()
+
+	mox.Conf.Static.Quarantine = &config.Quarantine{Reasons: []string{"dns-blocklisted"}}
+	defer func() {
+		mox.Conf.Static.Quarantine = nil
+	}()
+
+	// Message is accepted, but held in quarantine.
+	ts.run(func(err error, client *smtpclient.Client) {
+		mailFrom := "remote@example.org"
+		rcptTo := "mjl@mox.example"
+		if err == nil {
+			err = client.Deliver(ctxbg, mailFrom, rcptTo, int64(len(deliverMessage)), strings.NewReader(deliverMessage), false, false, false)
+		}
+		tcheck(t, err, "deliver")
+	})
+
+	l, err := admindb.QuarantineList(ctxbg, "mjl")
+	tcheck(t, err, "list quarantined messages")
+	if len(l) != 1 || l[0].Reason != "dns-blocklisted" || l[0].RcptTo != "mjl@mox.example" || l[0].Subject != "test" {
+		t.Fatalf("got quarantined messages %#v, expected one for dns-blocklisted", l)
+	}
+	ts.checkCount("Inbox", 0)
+
+	err = quarantine.Release(ctxbg, pkglog, "mjl", l[0].ID)
+	tcheck(t, err, "release")
+	ts.checkCount("Inbox", 1)
+}
+
+// Test a frontend node forwarding incoming messages to the storage node through
+// the queue, and a storage node accepting XCLIENT from a frontend node.
+func TestNodes(t *testing.T) {
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"example.org.": {"127.0.0.20"}, // For mx and iprev check.
+		},
+		TXT: map[string][]string{
+			"example.org.":        {"v=spf1 ip4:127.0.0.20 -all"},
+			"_dmarc.example.org.": {"v=DMARC1;p=reject"},
+		},
+		PTR: map[string][]string{
+			"127.0.0.20": {"example.org."}, // For iprev check.
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), resolver)
+	defer ts.close()
+	err := admindb.Init()
+	tcheck(t, err, "admindb init")
+	defer admindb.Close()
+
+	mox.Conf.Static.Transports = map[string]config.Transport{
+		"storage": {SMTP: &config.TransportSMTP{Host: "storage.mox.example"}},
+	}
+	mox.Conf.Static.Node = &config.Node{Role: "frontend", StorageTransport: "storage"}
+	defer func() {
+		mox.Conf.Static.Transports = nil
+		mox.Conf.Static.Node = nil
+	}()
+
+	// Frontend queues the message for the storage node.
+	ts.run(func(err error, client *smtpclient.Client) {
+		if err == nil {
+			err = client.Deliver(ctxbg, "remote@example.org", "mjl@mox.example", int64(len(deliverMessage)), strings.NewReader(deliverMessage), false, false, false)
+		}
+		tcheck(t, err, "deliver")
+	})
+	msgs, err := queue.List(ctxbg, queue.Filter{}, queue.Sort{})
+	tcheck(t, err, "listing queue")
+	if len(msgs) != 1 || msgs[0].Transport != "storage" || msgs[0].XClientAddr != "127.0.0.10" || msgs[0].XClientHelo != "mox.example" || msgs[0].SenderAccount != "" || msgs[0].MessageID != "<test@example.org>" {
+		t.Fatalf("got queue %#v, expected message for storage node", msgs)
+	}
+	ts.checkCount("Inbox", 0)
+
+	// Unknown recipients are rejected by the frontend.
+	ts.run(func(err error, client *smtpclient.Client) {
+		if err == nil {
+			err = client.Deliver(ctxbg, "remote@example.org", "unknown@mox.example", int64(len(deliverMessage)), strings.NewReader(deliverMessage), false, false, false)
+		}
+		ts.smtpErr(err, &smtpclient.Error{Permanent: true, Code: smtp.C550MailboxUnavail, Secode: smtp.SeAddr1UnknownDestMailbox1})
+	})
+
+	// Storage node does not offer XCLIENT to others.
+	mox.Conf.Static.Node = &config.Node{Role: "storage"}
+	ts.xclient = &smtpclient.XClient{Addr: net.ParseIP("127.0.0.20"), Helo: "mail.example.org"}
+	defer func() { ts.xclient = nil }()
+	ts.run(func(err error, client *smtpclient.Client) {
+		var cerr smtpclient.Error
+		if err == nil || !errors.As(err, &cerr) || !errors.Is(err, smtpclient.ErrProtocol) {
+			t.Fatalf("got err %v, expected protocol error for missing xclient support", err)
+		}
+	})
+
+	// Storage node delivers with the IP and EHLO hostname of the original connection.
+	_, ipnet, _ := net.ParseCIDR("127.0.0.0/8")
+	mox.Conf.Static.Node.FrontendNets = []net.IPNet{*ipnet}
+	ts.run(func(err error, client *smtpclient.Client) {
+		if err == nil {
+			err = client.Deliver(ctxbg, "remote@example.org", "mjl@mox.example", int64(len(deliverMessage)), strings.NewReader(deliverMessage), false, false, false)
+		}
+		tcheck(t, err, "deliver")
+	})
+	ts.checkCount("Inbox", 1)
+	m, err := bstore.QueryDB[store.Message](ctxbg, ts.acc.DB).Get()
+	tcheck(t, err, "get message")
+	if m.RemoteIP != "127.0.0.20" || m.EHLODomain != "mail.example.org" || !m.MailFromValidated {
+		t.Fatalf("got remote ip %q, ehlo %q, mail from validated %v, expected values from xclient", m.RemoteIP, m.EHLODomain, m.MailFromValidated)
+	}
+}
+
+// Test accepting messages as secondary MX, with recipient validation and queueing
+// for the primary.
+func TestSecondaryMX(t *testing.T) {
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"example.org.": {"127.0.0.10"}, // For mx check.
+		},
+		TXT: map[string][]string{
+			"example.org.":        {"v=spf1 ip4:127.0.0.10 -all"},
+			"_dmarc.example.org.": {"v=DMARC1;p=reject"},
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"example.org."}, // For iprev check.
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), resolver)
+	defer ts.close()
+	err := admindb.Init()
+	tcheck(t, err, "admindb init")
+	defer admindb.Close()
+
+	addrFile := filepath.Join(t.TempDir(), "addresses.txt")
+	err = os.WriteFile(addrFile, []byte("mjl@secondary.example\n"), 0660)
+	tcheck(t, err, "write address list")
+	mox.Conf.Static.Transports = map[string]config.Transport{
+		"primary": {SMTP: &config.TransportSMTP{Host: "primary.secondary.example"}},
+	}
+	mox.Conf.Static.SecondaryMX = &config.SecondaryMX{
+		Domains: map[string]config.SecondaryMXDomain{
+			"secondary.example": {Transport: "primary", AddressesFile: addrFile, Domain: dns.Domain{ASCII: "secondary.example"}},
+		},
+	}
+	defer func() {
+		mox.Conf.Static.Transports = nil
+		mox.Conf.Static.SecondaryMX = nil
+	}()
+
+	// Known address is queued for the primary.
+	ts.run(func(err error, client *smtpclient.Client) {
+		if err == nil {
+			err = client.Deliver(ctxbg, "remote@example.org", "mjl@secondary.example", int64(len(deliverMessage)), strings.NewReader(deliverMessage), false, false, false)
+		}
+		tcheck(t, err, "deliver")
+	})
+	msgs, err := queue.List(ctxbg, queue.Filter{}, queue.Sort{})
+	tcheck(t, err, "listing queue")
+	if len(msgs) != 1 || msgs[0].Transport != "primary" || msgs[0].RecipientDomain.Domain.ASCII != "secondary.example" || msgs[0].XClientAddr != "" || msgs[0].SenderAccount != "" {
+		t.Fatalf("got queue %#v, expected message for primary", msgs)
+	}
+	ts.checkCount("Inbox", 0)
+
+	// Unknown address is rejected immediately.
+	ts.run(func(err error, client *smtpclient.Client) {
+		if err == nil {
+			err = client.Deliver(ctxbg, "remote@example.org", "unknown@secondary.example", int64(len(deliverMessage)), strings.NewReader(deliverMessage), false, false, false)
+		}
+		ts.smtpErr(err, &smtpclient.Error{Permanent: true, Code: smtp.C550MailboxUnavail, Secode: smtp.SeAddr1UnknownDestMailbox1})
+	})
+
+	// Recipients for local accounts and secondary MX domains are not mixed.
+	ts.run(func(err error, client *smtpclient.Client) {
+		var rcptResps []smtpclient.Response
+		if err == nil {
+			rcptResps, err = client.DeliverMultiple(ctxbg, "remote@example.org", []string{"mjl@mox.example", "mjl@secondary.example"}, int64(len(deliverMessage)), strings.NewReader(deliverMessage), false, false, false)
+		}
+		tcheck(t, err, "deliver")
+		if len(rcptResps) != 2 || rcptResps[0].Code != smtp.C250Completed || rcptResps[1].Code != smtp.C452StorageFull {
+			t.Fatalf("got recipient responses %#v, expected second recipient in separate transaction", rcptResps)
+		}
+	})
+	ts.checkCount("Inbox", 1)
+}
+
+// Test scoring with DNS block and allow lists, with reject, greylist and tag
+// thresholds.
+func TestDNSBLScoring(t *testing.T) {
+	resolver := &dns.MockResolver{
+		A: map[string][]string{
+			"example.org.":              {"127.0.0.10"}, // For mx check.
+			"2.0.0.127.dnsbl.example.":  {"127.0.0.2"},  // For healthcheck.
+			"10.0.0.127.dnsbl.example.": {"127.0.0.10"}, // Where our connection pretends to come from.
+			"2.0.0.127.dnswl.example.":  {"127.0.0.2"},
+			"10.0.0.127.dnswl.example.": {"127.0.0.10"},
+		},
+		TXT: map[string][]string{
+			"example.org.":        {"v=spf1 ip4:127.0.0.10 -all"},
+			"_dmarc.example.org.": {"v=DMARC1;p=reject"},
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"example.org."}, // For iprev check.
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), resolver)
+	defer ts.close()
+	defer func() {
+		mox.Conf.Static.DNSBLScoring = nil
+	}()
+
+	deliver := func(expCode int) {
+		t.Helper()
+		ts.run(func(err error, client *smtpclient.Client) {
+			t.Helper()
+			mailFrom := "remote@example.org"
+			rcptTo := "mjl@mox.example"
+			if err == nil {
+				err = client.Deliver(ctxbg, mailFrom, rcptTo, int64(len(deliverMessage)), strings.NewReader(deliverMessage), false, false, false)
+			}
+			var cerr smtpclient.Error
+			if expCode == 0 {
+				tcheck(t, err, "deliver")
+			} else if err == nil || !errors.As(err, &cerr) || cerr.Code != expCode {
+				t.Fatalf("deliver, got err %v, expected smtp code %d", err, expCode)
+			}
+		})
+	}
+
+	bl := config.DNSBLScoreList{Zone: "dnsbl.example", Weight: 4, ZoneDomain: dns.Domain{ASCII: "dnsbl.example"}}
+	wl := config.DNSBLScoreList{Zone: "dnswl.example", Weight: -2, ZoneDomain: dns.Domain{ASCII: "dnswl.example"}}
+
+	// Greylisted on first attempt, accepted on retry after the delay.
+	mox.Conf.Static.DNSBLScoring = &config.DNSBLScoring{Lists: []config.DNSBLScoreList{bl}, GreylistScore: 3, GreylistDelay: time.Nanosecond}
+	deliver(smtp.C451LocalErr)
+	ts.checkCount("Inbox", 0)
+	deliver(0)
+	ts.checkCount("Inbox", 1)
+
+	// Allow list lowers score below tag threshold.
+	mox.Conf.Static.DNSBLScoring = &config.DNSBLScoring{Lists: []config.DNSBLScoreList{bl, wl}, TagScore: 3}
+	deliver(0)
+	ts.checkCount("Inbox", 2)
+
+	// Rejected at reject threshold.
+	mox.Conf.Static.DNSBLScoring = &config.DNSBLScoring{Lists: []config.DNSBLScoreList{bl, wl}, RejectScore: 2}
+	deliver(smtp.C451LocalErr)
+	ts.checkCount("Inbox", 2)
+
+	// Tagged, delivered to Junk.
+	mox.Conf.Static.DNSBLScoring = &config.DNSBLScoring{Lists: []config.DNSBLScoreList{bl}, TagScore: 3}
+	deliver(0)
+	ts.checkCount("Junk", 1)
+}
+
+// Test known senders bypass greylisting, and messages from first-time senders get
+// a header.
+func TestKnownSender(t *testing.T) {
+	resolver := &dns.MockResolver{
+		A: map[string][]string{
+			"example.org.":              {"127.0.0.10"}, // For mx check.
+			"2.0.0.127.dnsbl.example.":  {"127.0.0.2"},  // For healthcheck.
+			"10.0.0.127.dnsbl.example.": {"127.0.0.10"}, // Where our connection pretends to come from.
+		},
+		TXT: map[string][]string{
+			"example.org.":        {"v=spf1 ip4:127.0.0.10 -all"},
+			"_dmarc.example.org.": {"v=DMARC1;p=reject"},
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"example.org."}, // For iprev check.
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), resolver)
+	defer ts.close()
+	defer func() {
+		mox.Conf.Static.DNSBLScoring = nil
+	}()
+
+	deliver := func(expCode int) {
+		t.Helper()
+		ts.run(func(err error, client *smtpclient.Client) {
+			t.Helper()
+			mailFrom := "remote@example.org"
+			rcptTo := "mjl@mox.example"
+			if err == nil {
+				err = client.Deliver(ctxbg, mailFrom, rcptTo, int64(len(deliverMessage)), strings.NewReader(deliverMessage), false, false, false)
+			}
+			var cerr smtpclient.Error
+			if expCode == 0 {
+				tcheck(t, err, "deliver")
+			} else if err == nil || !errors.As(err, &cerr) || cerr.Code != expCode {
+				t.Fatalf("deliver, got err %v, expected smtp code %d", err, expCode)
+			}
+		})
+	}
+
+	checkFirstTime := func(expect bool) {
+		t.Helper()
+		m, err := bstore.QueryDB[store.Message](ctxbg, ts.acc.DB).SortDesc("ID").Limit(1).Get()
+		tcheck(t, err, "get last message")
+		firstTime := strings.Contains(string(m.Sealed.MsgPrefix), "X-Mox-First-Time-Sender: yes\r\n")
+		if firstTime != expect {
+			t.Fatalf("first-time sender header %v, expected %v", firstTime, expect)
+		}
+	}
+
+	// First message from sender gets the header, the next not.
+	deliver(0)
+	checkFirstTime(true)
+	deliver(0)
+	checkFirstTime(false)
+
+	// Unknown sender is greylisted. Start with clean greylisting state, earlier tests
+	// may have passed greylisting.
+	greylist.Lock()
+	greylist.entries = map[greylistKey]greylistEntry{}
+	greylist.Unlock()
+	bl := config.DNSBLScoreList{Zone: "dnsbl.example", Weight: 4, ZoneDomain: dns.Domain{ASCII: "dnsbl.example"}}
+	mox.Conf.Static.DNSBLScoring = &config.DNSBLScoring{Lists: []config.DNSBLScoreList{bl}, GreylistScore: 3, GreylistDelay: time.Hour}
+	deliver(smtp.C451LocalErr)
+	ts.checkCount("Inbox", 2)
+
+	// Contacts are not greylisted.
+	_, err := ts.acc.ContactSave(ctxbg, store.Contact{Name: "Remote", Emails: []string{"remote@example.org"}})
+	tcheck(t, err, "save contact")
+	deliver(0)
+	ts.checkCount("Inbox", 3)
+	checkFirstTime(false)
+}
+
+// Test the allow/deny sender lists of accounts and domains.
+func TestSenderList(t *testing.T) {
+	resolver := &dns.MockResolver{
+		A: map[string][]string{
+			"example.org.":              {"127.0.0.10"}, // For mx check.
+			"2.0.0.127.dnsbl.example.":  {"127.0.0.2"},  // For healthcheck.
+			"10.0.0.127.dnsbl.example.": {"127.0.0.10"}, // Where our connection pretends to come from.
+		},
+		TXT: map[string][]string{
+			"10.0.0.127.dnsbl.example.": {"blocklisted"},
+			"example.org.":              {"v=spf1 ip4:127.0.0.10 -all"},
+			"_dmarc.example.org.":       {"v=DMARC1;p=reject"},
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"example.org."}, // For iprev check.
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), resolver)
+	ts.dnsbls = []dns.Domain{{ASCII: "dnsbl.example"}}
+	defer ts.close()
+
+	deliver := func(expCode int) {
+		t.Helper()
+		ts.run(func(err error, client *smtpclient.Client) {
+			t.Helper()
+			mailFrom := "remote@example.org"
+			rcptTo := "mjl@mox.example"
+			if err == nil {
+				err = client.Deliver(ctxbg, mailFrom, rcptTo, int64(len(deliverMessage)), strings.NewReader(deliverMessage), false, false, false)
+			}
+			var cerr smtpclient.Error
+			if expCode == 0 {
+				tcheck(t, err, "deliver")
+			} else if err == nil || !errors.As(err, &cerr) || cerr.Code != expCode {
+				t.Fatalf("deliver, got err %v, expected smtp code %d", err, expCode)
+			}
+		})
+	}
+
+	// Without sender lists, the message is rejected because of the DNS block list.
+	deliver(smtp.C451LocalErr)
+
+	// Account allows the sender domain, validated through SPF and DMARC.
+	_, err := ts.acc.SenderListSet(ctxbg, "@Example.ORG", store.SenderAllow)
+	tcheck(t, err, "add sender list entry")
+	deliver(0)
+	ts.checkCount("Inbox", 1)
+
+	// More specific entry for the address takes precedence.
+	e, err := ts.acc.SenderListSet(ctxbg, "remote@example.org", store.SenderReject)
+	tcheck(t, err, "add sender list entry")
+	deliver(smtp.C550MailboxUnavail)
+	ts.checkCount("Inbox", 1)
+
+	// Account entries take precedence over domain entries.
+	domain := mox.Conf.Dynamic.Domains["mox.example"]
+	domain.SenderActions = map[string]string{"@example.org": "junk"}
+	mox.Conf.Dynamic.Domains["mox.example"] = domain
+	defer func() {
+		domain.SenderActions = nil
+		mox.Conf.Dynamic.Domains["mox.example"] = domain
+	}()
+	deliver(smtp.C550MailboxUnavail)
+
+	err = ts.acc.SenderListRemove(ctxbg, e.ID)
+	tcheck(t, err, "remove sender list entry")
+	l, err := ts.acc.SenderList(ctxbg)
+	tcheck(t, err, "list sender list")
+	err = ts.acc.SenderListRemove(ctxbg, l[0].ID)
+	tcheck(t, err, "remove sender list entry")
+
+	// Domain sends sender to Junk mailbox.
+	deliver(0)
+	ts.checkCount("Inbox", 1)
+	ts.checkCount("Junk", 1)
+
+	_, err = ts.acc.SenderListSet(ctxbg, "bogus", store.SenderAllow)
+	if !errors.Is(err, store.ErrSenderListParam) {
+		t.Fatalf("add bad sender, got err %v, expected ErrSenderListParam", err)
+	}
+}
+
+// Test messages to spamtraps are accepted but not delivered, are recorded, and
+// cause later messages from the same sender to be rejected.
+func TestSpamtrap(t *testing.T) {
+	resolver := &dns.MockResolver{
+		A: map[string][]string{
+			"example.org.": {"127.0.0.10"}, // For mx check.
+		},
+		TXT: map[string][]string{
+			"example.org.":        {"v=spf1 ip4:127.0.0.10 -all"},
+			"_dmarc.example.org.": {"v=DMARC1;p=reject"},
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"example.org."}, // For iprev check.
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), resolver)
+	defer ts.close()
+	err := admindb.Init()
+	tcheck(t, err, "admindb init")
+	defer admindb.Close()
+
+	domain := mox.Conf.Dynamic.Domains["mox.example"]
+	domain.SpamtrapLocalparts = map[smtp.Localpart]struct{}{"trap": {}}
+	mox.Conf.Dynamic.Domains["mox.example"] = domain
+	defer func() {
+		domain.SpamtrapLocalparts = nil
+		mox.Conf.Dynamic.Domains["mox.example"] = domain
+	}()
+
+	deliver := func(rcptTo string, expCode int) {
+		t.Helper()
+		ts.run(func(err error, client *smtpclient.Client) {
+			t.Helper()
+			mailFrom := "remote@example.org"
+			if err == nil {
+				err = client.Deliver(ctxbg, mailFrom, rcptTo, int64(len(deliverMessage)), strings.NewReader(deliverMessage), false, false, false)
+			}
+			var cerr smtpclient.Error
+			if expCode == 0 {
+				tcheck(t, err, "deliver")
+			} else if err == nil || !errors.As(err, &cerr) || cerr.Code != expCode {
+				t.Fatalf("deliver, got err %v, expected smtp code %d", err, expCode)
+			}
+		})
+	}
+
+	// Message for the spamtrap is accepted, but not delivered.
+	deliver("Trap@mox.example", 0)
+	ts.checkCount("Inbox", 0)
+
+	l, err := admindb.SpamtrapHitList(ctxbg, 0)
+	tcheck(t, err, "list spamtrap hits")
+	tcompare(t, len(l), 1)
+	tcompare(t, l[0].Trap, "trap@mox.example")
+	tcompare(t, l[0].Domain, "example.org")
+	tcompare(t, l[0].RemoteNetwork, "127.0.0.0")
+
+	// The sender now has a bad reputation, messages to regular addresses are rejected.
+	deliver("mjl@mox.example", smtp.C451LocalErr)
+	ts.checkCount("Inbox", 0)
+}
+
+// Test processing an abuse report at a role address.
+func TestAbuseReport(t *testing.T) {
+	resolver := &dns.MockResolver{
+		A: map[string][]string{
+			"example.org.": {"127.0.0.10"}, // For mx check.
+		},
+		TXT: map[string][]string{
+			"example.org.":        {"v=spf1 ip4:127.0.0.10 -all"},
+			"_dmarc.example.org.": {"v=DMARC1;p=reject"},
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"example.org."}, // For iprev check.
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), resolver)
+	defer ts.close()
+	err := admindb.Init()
+	tcheck(t, err, "admindb init")
+	defer admindb.Close()
+
+	// Message we sent earlier, that the report is about.
+	admindb.MessageEventAdd(ctxbg, pkglog, admindb.MessageEvent{MessageID: "<sent@mox.example>", QueueID: 1, Kind: admindb.EventQueued, Account: "mjl", Recipient: "user@example.org"})
+
+	report := strings.ReplaceAll(`From: <remote@example.org>
+To: <abuse@mox.example>
+Subject: abuse report
+Message-Id: <report@example.org>
+MIME-Version: 1.0
+Content-Type: multipart/report; report-type=feedback-report; boundary=x
+
+--x
+Content-Type: text/plain
+
+Spam complaint.
+
+--x
+Content-Type: message/feedback-report
+
+Feedback-Type: abuse
+User-Agent: test/1.0
+Version: 1
+Original-Rcpt-To: <user@example.org>
+
+--x
+Content-Type: text/rfc822-headers
+
+From: <mjl@mox.example>
+To: <user@example.org>
+Subject: newsletter
+Message-Id: <sent@mox.example>
+
+--x--
+`, "\n", "\r\n")
+
+	ts.run(func(err error, client *smtpclient.Client) {
+		mailFrom := "remote@example.org"
+		if err == nil {
+			err = client.Deliver(ctxbg, mailFrom, "abuse@mox.example", int64(len(report)), strings.NewReader(report), false, false, false)
+		}
+		tcheck(t, err, "deliver")
+	})
+
+	l, err := admindb.AbuseReportList(ctxbg, 0)
+	tcheck(t, err, "list abuse reports")
+	tcompare(t, len(l), 1)
+	tcompare(t, l[0].FeedbackType, "abuse")
+	tcompare(t, l[0].MessageID, "sent@mox.example")
+	tcompare(t, l[0].Account, "mjl")
+	tcompare(t, l[0].QueueIDs, []int64{1})
+	tcompare(t, l[0].Suppressed, []string{"user@example.org"})
+
+	sup, err := queue.SuppressionLookup(ctxbg, "mjl", smtp.Path{Localpart: "user", IPDomain: dns.IPDomain{Domain: dns.Domain{ASCII: "example.org"}}})
+	tcheck(t, err, "lookup suppression")
+	tcompare(t, sup != nil, true)
+}
+
 // Test accepting a DMARC report.
 func TestDMARCReport(t *testing.T) {
 	resolver := &dns.MockResolver{
@@ -1245,7 +1918,7 @@ func TestNonSMTP(t *testing.T) {
 		tlsConfig := &tls.Config{
 			Certificates: []tls.Certificate{fakeCert(ts.t)},
 		}
-		serve("test", ts.cid-2, dns.Domain{ASCII: "mox.example"}, tlsConfig, serverConn, ts.resolver, ts.submission, false, 100<<20, false, false, false, ts.dnsbls, 0)
+		serve("test", ts.cid-2, dns.Domain{ASCII: "mox.example"}, tlsConfig, serverConn, ts.resolver, ts.submission, false, 100<<20, false, false, false, ts.noPlaintextAuth, ts.dnsbls, 0)
 		close(serverdone)
 	}()
 
@@ -1332,7 +2005,23 @@ func TestQuota(t *testing.T) {
 		})
 	}
 
+	admindb.Close()
+	err := admindb.Init()
+	tcheck(t, err, "admindb init")
+	defer admindb.Close()
+
 	testDeliver("mjl@mox.example", &smtpclient.Error{Code: smtp.C452StorageFull, Secode: smtp.SeMailbox2Full2})
+
+	// The rejection is in the delivery history of the message.
+	events, err := admindb.MessageEventList(ctxbg, "test@example.org")
+	tcheck(t, err, "list message events")
+	if len(events) != 2 || events[0].Kind != admindb.EventReceived || events[1].Kind != admindb.EventRejected {
+		t.Fatalf("got events %#v, expected received and rejected", events)
+	}
+	e := events[1]
+	if e.Recipient != "mjl@mox.example" || e.Remote != "127.0.0.10" || !strings.HasPrefix(e.Result, "452 ") || e.Detail != "account storage full" {
+		t.Fatalf("bad rejected event %#v", e)
+	}
 }
 
 // Test with catchall destination address.
@@ -1501,6 +2190,8 @@ func TestPostmaster(t *testing.T) {
 	testDeliver("postmaster", nil)                  // Plain postmaster address without domain.
 	testDeliver("postmaster@host.mox.example", nil) // Postmaster address with configured mail server hostname.
 	testDeliver("postmaster@mox.example", nil)      // Postmaster address without explicitly configured destination.
+	testDeliver("abuse@mox.example", nil)           // Other role addresses too.
+	testDeliver("Security@mox.example", nil)
 	testDeliver("postmaster@unknown.example", &smtpclient.Error{Permanent: true, Code: smtp.C550MailboxUnavail, Secode: smtp.SeAddr1UnknownDestMailbox1})
 }
 
@@ -1642,7 +2333,7 @@ func TestSmuggle(t *testing.T) {
 	ts.tlsmode = smtpclient.TLSSkip
 	defer ts.close()
 
-	test := func(data string) {
+	test := func(data string, expPrefix string) {
 		t.Helper()
 
 		ts.runRaw(func(conn net.Conn) {
@@ -1685,17 +2376,44 @@ func TestSmuggle(t *testing.T) {
 			write("\r\n") // Empty header.
 			write(data)
 			write("\r\n.\r\n") // End of message.
-			line := readPrefixLine("5")
-			if !strings.Contains(line, "smug") {
+			line := readPrefixLine(expPrefix)
+			if expPrefix == "5" && !strings.Contains(line, "smug") {
 				t.Errorf("got 5xx error with message %q, expected error text containing smug", line)
 			}
 		})
 	}
 
-	test("\r\n.\n")
-	test("\n.\n")
-	test("\r.\r")
-	test("\n.\r\n")
+	test("\r\n.\n", "5")
+	test("\n.\n", "5")
+	test("\r.\r", "5")
+	test("\n.\r\n", "5")
+
+	// Anomalies are counted per remote IP.
+	sources, err := admindb.LineEndingSourceList(ctxbg, 0)
+	tcheck(t, err, "list line ending sources")
+	tcompare(t, len(sources), 1)
+	tcompare(t, sources[0].Messages, int64(4))
+	tcompare(t, sources[0].Rejected, int64(4))
+	tcompare(t, sources[0].BadDataEnd, int64(4))
+	tcompare(t, sources[0].BareCR, int64(1))
+
+	// Bare LF is accepted by default, and can be rejected.
+	test("bare\nlf", "2")
+	mox.Conf.Static.SMTPLineEndings = &config.SMTPLineEndings{BareLF: "reject"}
+	defer func() {
+		mox.Conf.Static.SMTPLineEndings = nil
+	}()
+	test("bare\nlf", "5")
+
+	// Bad data end can be logged only.
+	mox.Conf.Static.SMTPLineEndings = &config.SMTPLineEndings{BadDataEnd: "log"}
+	test("\r\n.\nmore", "2")
+
+	sources, err = admindb.LineEndingSourceList(ctxbg, 0)
+	tcheck(t, err, "list line ending sources")
+	tcompare(t, sources[0].Messages, int64(7))
+	tcompare(t, sources[0].Rejected, int64(5))
+	tcompare(t, sources[0].BareLF, int64(6))
 }
 
 func TestFutureRelease(t *testing.T) {
@@ -1898,3 +2616,327 @@ test email
 		ts.smtpErr(err, &smtpclient.Error{Permanent: true, Code: smtp.C554TransactionFailed, Secode: smtp.SeMsg6Other0})
 	})
 }
+
+// TestFooter checks a configured footer is added to submitted messages.
+func TestFooter(t *testing.T) {
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), dns.MockResolver{})
+	defer ts.close()
+
+	ts.user = "mjl@mox.example"
+	ts.pass = password0
+	ts.submission = true
+
+	dom, _ := mox.Conf.Domain(dns.Domain{ASCII: "mox.example"})
+	dom.Footer = &config.Footer{Text: []string{"Confidential."}}
+	mox.Conf.Dynamic.Domains["mox.example"] = dom
+
+	ts.run(func(err error, client *smtpclient.Client) {
+		tcheck(t, err, "init client")
+		mailFrom := "mjl@mox.example"
+		rcptTo := "remote@example.org"
+		err = client.Deliver(ctxbg, mailFrom, rcptTo, int64(len(submitMessage)), strings.NewReader(submitMessage), false, false, false)
+		tcheck(t, err, "deliver")
+	})
+	msgs, err := queue.List(ctxbg, queue.Filter{}, queue.Sort{})
+	tcheck(t, err, "queue list")
+	tcompare(t, len(msgs), 1)
+	f, err := queue.OpenMessage(ctxbg, msgs[0].ID)
+	tcheck(t, err, "open queued message")
+	defer f.Close()
+	buf, err := io.ReadAll(f)
+	tcheck(t, err, "read queued message")
+	tcompare(t, int64(len(buf)), msgs[0].Size)
+	if !strings.Contains(string(buf), "\r\n\r\nConfidential.\r\n") {
+		t.Fatalf("footer not found in message %q", buf)
+	}
+}
+
+// Test address rewrite rules are applied to the sender and recipients of
+// submitted messages, and to recipients of incoming messages.
+func TestAddressRewrite(t *testing.T) {
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"example.org.": {"127.0.0.10"}, // For mx check.
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"example.org."},
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), resolver)
+	defer ts.close()
+
+	mox.Conf.Dynamic.AddressRewrites = []config.AddressRewrite{
+		{AddressRegexp: `^(.+)@mox\.example$`, Replace: "$1@mox2.example", Sender: true},
+		{AddressRegexp: `^(.+)@legacy\.example$`, Replace: "$1@example.org", Recipient: true},
+		{AddressRegexp: `^old@mox\.example$`, Replace: "mjl@mox.example", Priority: 1, Recipient: true},
+	}
+	for i, ar := range mox.Conf.Dynamic.AddressRewrites {
+		mox.Conf.Dynamic.AddressRewrites[i].Address = regexp.MustCompile("(?i)" + ar.AddressRegexp)
+	}
+	defer func() {
+		mox.Conf.Dynamic.AddressRewrites = nil
+	}()
+
+	// Submission, with rewritten sender and recipient.
+	ts.user = "mjl@mox.example"
+	ts.pass = password0
+	ts.submission = true
+	ts.run(func(err error, client *smtpclient.Client) {
+		tcheck(t, err, "init client")
+		err = client.Deliver(ctxbg, "mjl@mox.example", "remote@legacy.example", int64(len(submitMessage)), strings.NewReader(submitMessage), false, false, false)
+		tcheck(t, err, "deliver")
+	})
+	msgs, err := queue.List(ctxbg, queue.Filter{}, queue.Sort{})
+	tcheck(t, err, "queue list")
+	tcompare(t, len(msgs), 1)
+	tcompare(t, msgs[0].Sender().String(), "mjl@mox2.example")
+	tcompare(t, msgs[0].Recipient().String(), "remote@example.org")
+	f, err := queue.OpenMessage(ctxbg, msgs[0].ID)
+	tcheck(t, err, "open queued message")
+	defer f.Close()
+	buf, err := io.ReadAll(f)
+	tcheck(t, err, "read queued message")
+	tcompare(t, int64(len(buf)), msgs[0].Size)
+	if !strings.Contains(string(buf), "\r\nFrom: <mjl@mox2.example>\r\n") || strings.Contains(string(buf), "mjl@mox.example>") {
+		t.Fatalf("from header not rewritten in message %q", buf)
+	}
+
+	// Incoming delivery for rewritten recipient address.
+	ts.submission = false
+	ts.user = ""
+	ts.run(func(err error, client *smtpclient.Client) {
+		tcheck(t, err, "init client")
+		err = client.Deliver(ctxbg, "remote@example.org", "old@mox.example", int64(len(deliverMessage)), strings.NewReader(deliverMessage), false, false, false)
+		tcheck(t, err, "deliver")
+	})
+	n, err := bstore.QueryDB[store.Message](ctxbg, ts.acc.DB).Count()
+	tcheck(t, err, "count messages")
+	tcompare(t, n, 1)
+}
+
+// Test filter rules configured by the user in webmail are applied during delivery.
+func TestFilterRules(t *testing.T) {
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"example.org.": {"127.0.0.10"}, // For mx check.
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"example.org."},
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), resolver)
+	defer ts.close()
+
+	_, err := ts.acc.FilterRuleSave(ctxbg, store.FilterRule{Subject: "filtered", Mailbox: "Filtered", Seen: true, Keywords: []string{"filtered"}, ForwardTo: "other@remote.example"})
+	tcheck(t, err, "save filter rule")
+	_, err = ts.acc.FilterRuleSave(ctxbg, store.FilterRule{Subject: "discard", Discard: true})
+	tcheck(t, err, "save filter rule")
+
+	deliver := func(subject string) {
+		t.Helper()
+		ts.run(func(err error, client *smtpclient.Client) {
+			t.Helper()
+			msg := strings.ReplaceAll(deliverMessage, "Subject: test", "Subject: "+subject)
+			if err == nil {
+				err = client.Deliver(ctxbg, "remote@example.org", "mjl@mox.example", int64(len(msg)), strings.NewReader(msg), false, false, false)
+			}
+			tcheck(t, err, "deliver")
+		})
+	}
+
+	deliver("filtered message")
+	ts.checkCount("Filtered", 1)
+	m, err := bstore.QueryDB[store.Message](ctxbg, ts.acc.DB).Get()
+	tcheck(t, err, "get message")
+	tcompare(t, m.Seen, true)
+	tcompare(t, m.Keywords, []string{"filtered"})
+	mb, err := bstore.QueryDB[store.Mailbox](ctxbg, ts.acc.DB).FilterNonzero(store.Mailbox{Name: "Filtered"}).Get()
+	tcheck(t, err, "get mailbox")
+	tcompare(t, mb.Keywords, []string{"filtered"})
+
+	msgs, err := queue.List(ctxbg, queue.Filter{}, queue.Sort{})
+	tcheck(t, err, "list queue")
+	tcompare(t, len(msgs), 1)
+	tcompare(t, msgs[0].Recipient().String(), "other@remote.example")
+	tcompare(t, msgs[0].Sender().String(), "mjl@mox.example")
+
+	deliver("discard this")
+	n, err := bstore.QueryDB[store.Message](ctxbg, ts.acc.DB).Count()
+	tcheck(t, err, "count messages")
+	tcompare(t, n, 1)
+}
+
+// Test authentication with TLS client certificates, with SASL EXTERNAL.
+func TestAuthExternal(t *testing.T) {
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), dns.MockResolver{})
+	defer ts.close()
+	ts.submission = true
+
+	caKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize)) // Fake key, don't use this for real!
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caBuf, err := x509.CreateCertificate(cryptorand.Reader, caTmpl, caTmpl, caKey.Public(), caKey)
+	tcheck(t, err, "create ca certificate")
+	ca, err := x509.ParseCertificate(caBuf)
+	tcheck(t, err, "parse ca certificate")
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	_, clientKey, err := ed25519.GenerateKey(cryptorand.Reader)
+	tcheck(t, err, "generate client key")
+	clientTmpl := &x509.Certificate{
+		SerialNumber:   big.NewInt(2),
+		Subject:        pkix.Name{CommonName: "mjl@mox.example"},
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(time.Hour),
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		EmailAddresses: []string{"unknown@mox.example"},
+	}
+	clientBuf, err := x509.CreateCertificate(cryptorand.Reader, clientTmpl, ca, clientKey.Public(), caKey)
+	tcheck(t, err, "create client certificate")
+	clientCert := tls.Certificate{Certificate: [][]byte{clientBuf}, PrivateKey: clientKey}
+
+	ts.serverTLSConfig = &tls.Config{
+		Certificates: []tls.Certificate{fakeCert(t)},
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		ClientCAs:    pool,
+	}
+
+	test := func(certs []tls.Certificate, authz string, expMech bool, expCode string) {
+		t.Helper()
+
+		ts.runRaw(func(conn net.Conn) {
+			t.Helper()
+			defer conn.Close()
+
+			br := bufio.NewReader(conn)
+			// readResponse reads a possibly multiline response, returning its lines.
+			readResponse := func(prefix string) []string {
+				t.Helper()
+				var lines []string
+				for {
+					line, err := br.ReadString('\n')
+					tcheck(t, err, "read response")
+					line = strings.TrimRight(line, "\r\n")
+					if !strings.HasPrefix(line, prefix) {
+						t.Fatalf("got smtp response %q, expected prefix %q", line, prefix)
+					}
+					lines = append(lines, line)
+					if len(line) < 4 || line[3] != '-' {
+						return lines
+					}
+				}
+			}
+			write := func(s string) {
+				t.Helper()
+				_, err := fmt.Fprintf(conn, "%s\r\n", s)
+				tcheck(t, err, "write")
+			}
+
+			readResponse("220")
+			write("EHLO mox.example")
+			readResponse("250")
+			write("STARTTLS")
+			readResponse("220")
+			tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true, Certificates: certs})
+			conn = tlsConn
+			br = bufio.NewReader(conn)
+			write("EHLO mox.example")
+			var hasMech bool
+			for _, line := range readResponse("250") {
+				hasMech = hasMech || strings.HasPrefix(line[4:], "AUTH ") && strings.Contains(line, " EXTERNAL")
+			}
+			if hasMech != expMech {
+				t.Fatalf("external mechanism announced %v, expected %v", hasMech, expMech)
+			}
+			resp := "="
+			if authz != "" {
+				resp = base64.StdEncoding.EncodeToString([]byte(authz))
+			}
+			write("AUTH EXTERNAL " + resp)
+			readResponse(expCode)
+		})
+	}
+
+	// Common name of subject is used, address in alternative names is not an account.
+	test([]tls.Certificate{clientCert}, "", true, "235")
+	test([]tls.Certificate{clientCert}, "mjl@mox.example", true, "235")
+	test([]tls.Certificate{clientCert}, "unknown@mox.example", true, "535")
+	test([]tls.Certificate{clientCert}, "other@mox.example", true, "535")
+	test(nil, "", true, "535")
+
+	// Not possible without client authentication on the listener.
+	ts.serverTLSConfig = nil
+	test([]tls.Certificate{clientCert}, "", false, "504")
+}
+
+// Test that TLS client fingerprints are stored with delivered messages, and that
+// TLS handshakes fail for clients matching a reject rule.
+func TestTLSFingerprint(t *testing.T) {
+	resolver := dns.MockResolver{
+		A: map[string][]string{
+			"example.org.": {"127.0.0.10"}, // For mx check.
+		},
+		PTR: map[string][]string{
+			"127.0.0.10": {"example.org."},
+		},
+	}
+	ts := newTestServer(t, filepath.FromSlash("../testdata/smtp/mox.conf"), resolver)
+	defer ts.close()
+	defer func() {
+		mox.Conf.Static.TLSFingerprintRules = nil
+	}()
+
+	ts.serverTLSConfig = mox.TLSFingerprintConfig(&tls.Config{Certificates: []tls.Certificate{fakeCert(t)}}, "smtp")
+
+	deliver := func() {
+		t.Helper()
+		ts.run(func(err error, client *smtpclient.Client) {
+			t.Helper()
+			if err == nil {
+				err = client.Deliver(ctxbg, "remote@example.org", "mjl@mox.example", int64(len(deliverMessage)), strings.NewReader(deliverMessage), false, true, false)
+			}
+			tcheck(t, err, "deliver")
+		})
+	}
+
+	lastFingerprint := func() string {
+		t.Helper()
+		q := bstore.QueryDB[store.Message](ctxbg, ts.acc.DB)
+		q.SortDesc("ID")
+		q.Limit(1)
+		m, err := q.Get()
+		tcheck(t, err, "get message")
+		return m.ReceivedTLSFingerprint
+	}
+
+	deliver()
+	fp := lastFingerprint()
+	if !strings.HasPrefix(fp, "t13d") {
+		t.Fatalf("got fingerprint %q, expected ja4 fingerprint for tls 1.3 with server name", fp)
+	}
+
+	// Rule that only logs.
+	mox.Conf.Static.TLSFingerprintRules = []config.TLSFingerprintRule{{JA4: fp, Action: "log"}}
+	deliver()
+
+	// Rule for other protocols.
+	mox.Conf.Static.TLSFingerprintRules = []config.TLSFingerprintRule{{JA4: fp, Protocols: []string{"imap", "imaps"}}}
+	deliver()
+
+	// Reject rule with wildcards.
+	a, _, _ := strings.Cut(fp, "_")
+	mox.Conf.Static.TLSFingerprintRules = []config.TLSFingerprintRule{{JA3: "00000000000000000000000000000000"}, {JA4: a + "_*_*"}}
+	ts.run(func(err error, client *smtpclient.Client) {
+		if err == nil {
+			t.Fatalf("tls handshake succeeded, expected failure due to fingerprint rule")
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package smtpserver
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/mox/admindb"
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/publicsuffix"
+	"github.com/mjl-/mox/store"
+)
+
+var metricSpamtrapHit = promauto.NewCounter(
+	prometheus.CounterOpts{
+		Name: "mox_smtpserver_spamtrap_hit_total",
+		Help: "Incoming messages for spamtrap addresses, counted per recipient.",
+	},
+)
+
+// How long spamtrap hits give the remote network and sender domain a bad
+// reputation.
+const spamtrapReputationPeriod = 30 * 24 * time.Hour
+
+// How long spamtrap hits are kept for reporting.
+const spamtrapKeep = 90 * 24 * time.Hour
+
+// spamtrapSenderDomain returns the organizational domain of the validated sender
+// of a message: of the message From address with DMARC pass, or otherwise of the
+// SPF-validated MAIL FROM address. An empty string is returned if neither was
+// validated.
+func spamtrapSenderDomain(ctx context.Context, log mlog.Log, msgFromDomain string, msgFromValidated bool, mailFromDomain string, mailFromValidated bool) string {
+	var ds string
+	if msgFromValidated {
+		ds = msgFromDomain
+	} else if mailFromValidated {
+		ds = mailFromDomain
+	}
+	if ds == "" {
+		return ""
+	}
+	d, err := dns.ParseDomain(ds)
+	if err != nil {
+		return ""
+	}
+	return publicsuffix.Lookup(ctx, log.Logger, d).Name()
+}
+
+// spamtrapRecord records a message for a spamtrap, removing old hits. If train is
+// set, the shared junk filter is trained with the message as junk.
+func spamtrapRecord(ctx context.Context, log mlog.Log, h admindb.SpamtrapHit, train bool, msgFile io.ReaderAt) {
+	metricSpamtrapHit.Inc()
+	log.Info("message for spamtrap, not delivering",
+		slog.String("trap", h.Trap),
+		slog.String("remoteip", h.RemoteIP),
+		slog.String("domain", h.Domain))
+	if err := admindb.SpamtrapHitAdd(ctx, &h); err != nil {
+		log.Errorx("recording spamtrap hit", err)
+	}
+	if n, err := admindb.SpamtrapHitsRemoveBefore(ctx, time.Now().Add(-spamtrapKeep)); err != nil {
+		log.Errorx("removing old spamtrap hits", err)
+	} else if n > 0 {
+		log.Debug("removed old spamtrap hits", slog.Int("count", n))
+	}
+	if train {
+		if err := store.TrainSharedJunk(ctx, log, msgFile, h.Size); err != nil {
+			log.Errorx("training shared junk filter with spamtrap message", err)
+		}
+	}
+}
+
+// spamtrapListed returns whether the remote network or the sender domain sent
+// messages to spamtraps recently.
+func spamtrapListed(ctx context.Context, network, domain string) (bool, error) {
+	n, err := admindb.SpamtrapHitsSince(ctx, time.Now().Add(-spamtrapReputationPeriod), network, domain)
+	return n > 0, err
+}
@@ -0,0 +1,281 @@
+// Package spamscan implements clients for external spam scanners: rspamd
+// through its HTTP protocol, and SpamAssassin's spamd through its SPAMC
+// protocol.
+//
+// Scanners return a score and the score from which they consider a message spam.
+// The score can be scaled to a probability, for combining with the junk filter.
+package spamscan
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/moxvar"
+	"github.com/mjl-/mox/stub"
+)
+
+var (
+	MetricScan        stub.HistogramVec = stub.HistogramVecIgnore{}
+	HTTPClientObserve                   = stub.HTTPClientObserveIgnore
+)
+
+// ErrScan is returned for failed scans, e.g. due to connection errors or
+// unexpected responses.
+var ErrScan = errors.New("spamscan: scan failed")
+
+// Meta holds information about the SMTP transaction, passed to scanners.
+type Meta struct {
+	RemoteIP string
+	EHLO     string
+	MailFrom string // Can be empty.
+	RcptTo   string
+	User     string // For spamd, for per-user preferences.
+}
+
+// Result is the outcome of a scan.
+type Result struct {
+	Scanner  string // "rspamd" or "spamd".
+	Spam     bool   // Whether the scanner considers the message spam.
+	Score    float64
+	Required float64 // Score from which the scanner considers a message spam.
+	Action   string  // For rspamd, e.g. "no action", "add header", "reject".
+	Symbols  []string
+}
+
+// Probability returns the score scaled to a spam probability between 0 and 1,
+// such that the required score of the scanner results in threshold. Negative
+// scores result in probability 0.
+func (r Result) Probability(threshold float64) float64 {
+	if r.Required <= 0 {
+		if r.Spam {
+			return 1
+		}
+		return 0
+	}
+	return max(0, min(1, r.Score/r.Required*threshold))
+}
+
+// Scanner scores a message.
+type Scanner interface {
+	Scan(ctx context.Context, log mlog.Log, meta Meta, msg io.Reader, size int64) (Result, error)
+}
+
+// Rspamd scans messages with rspamd.
+type Rspamd struct {
+	URL      string // Base URL, e.g. http://localhost:11333.
+	Password string // Optional.
+}
+
+var httpClient = &http.Client{}
+
+// Scan submits the message to the /checkv2 endpoint of rspamd.
+func (s Rspamd) Scan(ctx context.Context, log mlog.Log, meta Meta, msg io.Reader, size int64) (rresult Result, rerr error) {
+	start := time.Now()
+	defer func() {
+		observe(log, "rspamd", start, rresult, rerr)
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", strings.TrimRight(s.URL, "/")+"/checkv2", msg)
+	if err != nil {
+		return Result{}, fmt.Errorf("%w: new request: %v", ErrScan, err)
+	}
+	req.ContentLength = size
+	req.Header.Set("User-Agent", "mox/"+moxvar.Version)
+	req.Header.Set("IP", meta.RemoteIP)
+	req.Header.Set("Helo", meta.EHLO)
+	req.Header.Set("From", meta.MailFrom)
+	req.Header.Set("Rcpt", meta.RcptTo)
+	req.Header.Set("Deliver-To", meta.RcptTo)
+	if s.Password != "" {
+		req.Header.Set("Password", s.Password)
+	}
+	resp, err := httpClient.Do(req)
+	code := 0
+	if resp != nil {
+		code = resp.StatusCode
+	}
+	HTTPClientObserve(ctx, log.Logger, "spamscan", req.Method, code, err, start)
+	if err != nil {
+		return Result{}, fmt.Errorf("%w: http request: %v", ErrScan, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		buf, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return Result{}, fmt.Errorf("%w: http response %s: %s", ErrScan, resp.Status, strings.TrimSpace(string(buf)))
+	}
+
+	var r struct {
+		IsSkipped     bool    `json:"is_skipped"`
+		Score         float64 `json:"score"`
+		RequiredScore float64 `json:"required_score"`
+		Action        string  `json:"action"`
+		Symbols       map[string]struct {
+			Score float64 `json:"score"`
+		} `json:"symbols"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1024*1024)).Decode(&r); err != nil {
+		return Result{}, fmt.Errorf("%w: parsing response: %v", ErrScan, err)
+	}
+	if r.IsSkipped {
+		return Result{}, fmt.Errorf("%w: message skipped by rspamd", ErrScan)
+	}
+	result := Result{
+		Scanner:  "rspamd",
+		Spam:     r.Action == "reject" || r.Action == "add header" || r.Action == "rewrite subject" || r.RequiredScore > 0 && r.Score >= r.RequiredScore,
+		Score:    r.Score,
+		Required: r.RequiredScore,
+		Action:   r.Action,
+	}
+	for name, sym := range r.Symbols {
+		if sym.Score != 0 {
+			result.Symbols = append(result.Symbols, name)
+		}
+	}
+	slices.Sort(result.Symbols)
+	return result, nil
+}
+
+// Spamd scans messages with SpamAssassin's spamd.
+type Spamd struct {
+	Address string // host:port, or absolute path to unix domain socket.
+	User    string // If empty, meta.User is used.
+}
+
+// Scan sends the message to spamd with a SYMBOLS request.
+func (s Spamd) Scan(ctx context.Context, log mlog.Log, meta Meta, msg io.Reader, size int64) (rresult Result, rerr error) {
+	start := time.Now()
+	defer func() {
+		observe(log, "spamd", start, rresult, rerr)
+	}()
+
+	network := "tcp"
+	if strings.HasPrefix(s.Address, "/") {
+		network = "unix"
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, s.Address)
+	if err != nil {
+		return Result{}, fmt.Errorf("%w: dial: %v", ErrScan, err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	user := s.User
+	if user == "" {
+		user = meta.User
+	}
+	// The SPAMC protocol is described in spamd/PROTOCOL in the SpamAssassin sources.
+	bw := bufio.NewWriter(conn)
+	fmt.Fprintf(bw, "SYMBOLS SPAMC/1.5\r\nContent-length: %d\r\n", size)
+	if user != "" {
+		fmt.Fprintf(bw, "User: %s\r\n", user)
+	}
+	fmt.Fprintf(bw, "\r\n")
+	if _, err := io.Copy(bw, msg); err != nil {
+		return Result{}, fmt.Errorf("%w: writing message: %v", ErrScan, err)
+	}
+	if err := bw.Flush(); err != nil {
+		return Result{}, fmt.Errorf("%w: writing message: %v", ErrScan, err)
+	}
+
+	return parseSpamdResponse(bufio.NewReader(io.LimitReader(conn, 1024*1024)))
+}
+
+func parseSpamdResponse(br *bufio.Reader) (Result, error) {
+	readLine := func() (string, error) {
+		line, err := br.ReadString('\n')
+		if err != nil && !(err == io.EOF && line != "") {
+			return "", fmt.Errorf("%w: reading response: %v", ErrScan, err)
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	// E.g. "SPAMD/1.1 0 EX_OK".
+	line, err := readLine()
+	if err != nil {
+		return Result{}, err
+	}
+	t := strings.SplitN(line, " ", 3)
+	if len(t) != 3 || !strings.HasPrefix(t[0], "SPAMD/") {
+		return Result{}, fmt.Errorf("%w: unexpected response %q", ErrScan, line)
+	} else if t[1] != "0" {
+		return Result{}, fmt.Errorf("%w: error response %q", ErrScan, line)
+	}
+
+	result := Result{Scanner: "spamd"}
+	var haveSpam bool
+	for {
+		line, err := readLine()
+		if err != nil {
+			return Result{}, err
+		}
+		if line == "" {
+			break
+		}
+		k, v, ok := strings.Cut(line, ":")
+		if !ok || !strings.EqualFold(k, "Spam") {
+			continue
+		}
+		// E.g. "True ; 15.2 / 5.0".
+		verdict, scores, ok := strings.Cut(v, ";")
+		score, required, ok2 := strings.Cut(scores, "/")
+		if !ok || !ok2 {
+			return Result{}, fmt.Errorf("%w: malformed spam header %q", ErrScan, line)
+		}
+		result.Spam = strings.EqualFold(strings.TrimSpace(verdict), "true") || strings.EqualFold(strings.TrimSpace(verdict), "yes")
+		result.Score, err = strconv.ParseFloat(strings.TrimSpace(score), 64)
+		if err == nil {
+			result.Required, err = strconv.ParseFloat(strings.TrimSpace(required), 64)
+		}
+		if err != nil {
+			return Result{}, fmt.Errorf("%w: parsing score in spam header %q: %v", ErrScan, line, err)
+		}
+		haveSpam = true
+	}
+	if !haveSpam {
+		return Result{}, fmt.Errorf("%w: missing spam header in response", ErrScan)
+	}
+
+	// Remainder is a comma-separated list of matched rules.
+	buf, err := io.ReadAll(br)
+	if err != nil {
+		return Result{}, fmt.Errorf("%w: reading symbols: %v", ErrScan, err)
+	}
+	for _, s := range strings.Split(strings.TrimSpace(string(buf)), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			result.Symbols = append(result.Symbols, s)
+		}
+	}
+	return result, nil
+}
+
+func observe(log mlog.Log, scanner string, start time.Time, r Result, err error) {
+	result := "ham"
+	if err != nil {
+		result = "error"
+	} else if r.Spam {
+		result = "spam"
+	}
+	MetricScan.ObserveLabels(float64(time.Since(start))/float64(time.Second), scanner, result)
+	log.Debugx("spam scan", err,
+		slog.String("scanner", scanner),
+		slog.Bool("spam", r.Spam),
+		slog.Float64("score", r.Score),
+		slog.Float64("required", r.Required),
+		slog.Any("symbols", r.Symbols),
+		slog.Duration("duration", time.Since(start)))
+}
@@ -0,0 +1,156 @@
+package spamscan
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/mjl-/mox/mlog"
+)
+
+var ctxbg = context.Background()
+var pkglog = mlog.New("spamscan", nil)
+
+const msg = "From: <remote@example.org>\r\nSubject: test\r\n\r\nbody\r\n"
+
+func tcheck(t *testing.T, err error, msg string) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %s", msg, err)
+	}
+}
+
+func tcompare(t *testing.T, got, expect any) {
+	t.Helper()
+	if !reflect.DeepEqual(got, expect) {
+		t.Fatalf("got:\n%#v\nexpected:\n%#v", got, expect)
+	}
+}
+
+func TestRspamd(t *testing.T) {
+	meta := Meta{RemoteIP: "10.0.0.1", EHLO: "mail.example.org", MailFrom: "remote@example.org", RcptTo: "mjl@mox.example", User: "mjl"}
+
+	var response string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/checkv2" || r.Header.Get("IP") != meta.RemoteIP || r.Header.Get("Rcpt") != meta.RcptTo || r.Header.Get("Password") != "secret" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if buf, err := io.ReadAll(r.Body); err != nil || string(buf) != msg {
+			http.Error(w, "bad message", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, response)
+	}))
+	defer srv.Close()
+
+	s := Rspamd{URL: srv.URL + "/", Password: "secret"}
+	response = `{"is_skipped":false,"score":16.5,"required_score":15,"action":"reject","symbols":{"BAYES_SPAM":{"name":"BAYES_SPAM","score":5.1},"ARC_NA":{"name":"ARC_NA","score":0}}}`
+	r, err := s.Scan(ctxbg, pkglog, meta, strings.NewReader(msg), int64(len(msg)))
+	tcheck(t, err, "scan")
+	tcompare(t, r, Result{Scanner: "rspamd", Spam: true, Score: 16.5, Required: 15, Action: "reject", Symbols: []string{"BAYES_SPAM"}})
+
+	response = `{"is_skipped":false,"score":-1.5,"required_score":15,"action":"no action","symbols":{}}`
+	r, err = s.Scan(ctxbg, pkglog, meta, strings.NewReader(msg), int64(len(msg)))
+	tcheck(t, err, "scan")
+	tcompare(t, r.Spam, false)
+	tcompare(t, r.Probability(0.95), 0.0)
+
+	response = `{"is_skipped":true}`
+	_, err = s.Scan(ctxbg, pkglog, meta, strings.NewReader(msg), int64(len(msg)))
+	if !errors.Is(err, ErrScan) {
+		t.Fatalf("got err %v, expected ErrScan for skipped message", err)
+	}
+
+	s.Password = "wrong"
+	_, err = s.Scan(ctxbg, pkglog, meta, strings.NewReader(msg), int64(len(msg)))
+	if !errors.Is(err, ErrScan) {
+		t.Fatalf("got err %v, expected ErrScan for error response", err)
+	}
+}
+
+func TestSpamd(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	tcheck(t, err, "listen")
+	defer ln.Close()
+
+	var response string
+	done := make(chan error, 1)
+	serve := func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+		br := bufio.NewReader(conn)
+		line, err := br.ReadString('\n')
+		if err == nil && line != "SYMBOLS SPAMC/1.5\r\n" {
+			err = fmt.Errorf("unexpected request line %q", line)
+		}
+		var size int
+		var user string
+		for err == nil {
+			line, err = br.ReadString('\n')
+			line = strings.TrimRight(line, "\r\n")
+			if line == "" {
+				break
+			}
+			k, v, _ := strings.Cut(line, ": ")
+			switch k {
+			case "Content-length":
+				size, err = strconv.Atoi(v)
+			case "User":
+				user = v
+			}
+		}
+		buf := make([]byte, size)
+		if err == nil {
+			_, err = io.ReadFull(br, buf)
+		}
+		if err == nil && (string(buf) != msg || user != "mjl") {
+			err = fmt.Errorf("unexpected message %q or user %q", buf, user)
+		}
+		if err == nil {
+			_, err = fmt.Fprint(conn, response)
+		}
+		done <- err
+	}
+
+	s := Spamd{Address: ln.Addr().String()}
+	meta := Meta{User: "mjl"}
+
+	response = "SPAMD/1.1 0 EX_OK\r\nContent-length: 31\r\nSpam: True ; 7.5 / 5.0\r\n\r\nHTML_MESSAGE,MISSING_DATE\r\n"
+	go serve()
+	r, err := s.Scan(ctxbg, pkglog, meta, strings.NewReader(msg), int64(len(msg)))
+	tcheck(t, err, "scan")
+	tcheck(t, <-done, "server")
+	tcompare(t, r, Result{Scanner: "spamd", Spam: true, Score: 7.5, Required: 5, Symbols: []string{"HTML_MESSAGE", "MISSING_DATE"}})
+	tcompare(t, r.Probability(0.9), 1.0)
+
+	response = "SPAMD/1.1 0 EX_OK\r\nSpam: False ; 2.5 / 5.0\r\n\r\n"
+	go serve()
+	r, err = s.Scan(ctxbg, pkglog, meta, strings.NewReader(msg), int64(len(msg)))
+	tcheck(t, err, "scan")
+	tcheck(t, <-done, "server")
+	tcompare(t, r, Result{Scanner: "spamd", Score: 2.5, Required: 5})
+	tcompare(t, r.Probability(0.9), 0.45)
+
+	response = "SPAMD/1.0 76 Bad header line\r\n"
+	go serve()
+	_, err = s.Scan(ctxbg, pkglog, meta, strings.NewReader(msg), int64(len(msg)))
+	tcheck(t, <-done, "server")
+	if !errors.Is(err, ErrScan) {
+		t.Fatalf("got err %v, expected ErrScan for error response", err)
+	}
+}
@@ -27,6 +27,8 @@ import (
 	cryptorand "crypto/rand"
 	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
 	"encoding"
 	"encoding/json"
 	"errors"
@@ -34,6 +36,7 @@ import (
 	"hash"
 	"io"
 	"log/slog"
+	"net"
 	"os"
 	"path/filepath"
 	"runtime/debug"
@@ -52,11 +55,13 @@ import (
 
 	"github.com/mjl-/mox/config"
 	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/extauth"
 	"github.com/mjl-/mox/message"
 	"github.com/mjl-/mox/metrics"
 	"github.com/mjl-/mox/mlog"
 	"github.com/mjl-/mox/mox-"
 	"github.com/mjl-/mox/moxio"
+	"github.com/mjl-/mox/oidc"
 	"github.com/mjl-/mox/publicsuffix"
 	"github.com/mjl-/mox/scram"
 	"github.com/mjl-/mox/smtp"
@@ -73,8 +78,31 @@ var (
 	ErrUnknownCredentials = errors.New("credentials not found")
 	ErrAccountUnknown     = errors.New("no such account")
 	ErrOverQuota          = errors.New("account over quota")
+	ErrLoginDisabled      = errors.New("login disabled for account")
 )
 
+// loginDisabledError is returned for authentication attempts for an account with
+// LoginDisabled set. It matches both ErrLoginDisabled and ErrUnknownCredentials,
+// so callers that only know about the latter treat it as a failed login.
+type loginDisabledError string
+
+func (e loginDisabledError) Error() string {
+	return "login disabled: " + string(e)
+}
+
+func (e loginDisabledError) Is(target error) bool {
+	return target == ErrLoginDisabled || target == ErrUnknownCredentials
+}
+
+// CheckLoginDisabled returns an error matching ErrLoginDisabled if logins are
+// disabled for the account.
+func CheckLoginDisabled(accountName string) error {
+	if conf, ok := mox.Conf.Account(accountName); ok && conf.LoginDisabled != "" {
+		return loginDisabledError(conf.LoginDisabled)
+	}
+	return nil
+}
+
 var DefaultInitialMailboxes = config.InitialMailboxes{
 	SpecialUse: config.SpecialUseMailboxes{
 		Sent:    "Sent",
@@ -153,6 +181,13 @@ type Password struct {
 	CRAMMD5     CRAMMD5 // For SASL CRAM-MD5.
 	SCRAMSHA1   SCRAM   // For SASL SCRAM-SHA-1.
 	SCRAMSHA256 SCRAM   // For SASL SCRAM-SHA-256.
+	SCRAMSHA512 SCRAM   // For SASL SCRAM-SHA-512. Absent for passwords set before support was added.
+
+	// Set by an admin to require the user to change the password. Until then, the
+	// password is only accepted for logins to the account web interface, app
+	// passwords can still be used for IMAP and SMTP. Cleared when a new password is
+	// set.
+	ChangeRequired bool
 }
 
 // Subjectpass holds the secret key used to sign subjectpass tokens.
@@ -221,10 +256,14 @@ type MailboxCounts struct {
 	Unread  int64 // Messages without \Seen, excluding those with \Deleted, for JMAP.
 	Unseen  int64 // Messages without \Seen, including those with \Deleted, for IMAP.
 	Size    int64 // Number of bytes for all messages.
+
+	// Number of bytes for messages with \Deleted flag, i.e. the storage that is
+	// reclaimed by expunging. For IMAP STATUS DELETED-STORAGE.
+	DeletedSize int64
 }
 
 func (mc MailboxCounts) String() string {
-	return fmt.Sprintf("%d total, %d deleted, %d unread, %d unseen, size %d bytes", mc.Total, mc.Deleted, mc.Unread, mc.Unseen, mc.Size)
+	return fmt.Sprintf("%d total, %d deleted, %d unread, %d unseen, size %d bytes, deleted size %d bytes", mc.Total, mc.Deleted, mc.Unread, mc.Unseen, mc.Size, mc.DeletedSize)
 }
 
 // Add increases mailbox counts mc with those of delta.
@@ -234,6 +273,7 @@ func (mc *MailboxCounts) Add(delta MailboxCounts) {
 	mc.Unread += delta.Unread
 	mc.Unseen += delta.Unseen
 	mc.Size += delta.Size
+	mc.DeletedSize += delta.DeletedSize
 }
 
 // Add decreases mailbox counts mc with those of delta.
@@ -243,6 +283,7 @@ func (mc *MailboxCounts) Sub(delta MailboxCounts) {
 	mc.Unread -= delta.Unread
 	mc.Unseen -= delta.Unseen
 	mc.Size -= delta.Size
+	mc.DeletedSize -= delta.DeletedSize
 }
 
 // SpecialUse identifies a specific role for a mailbox, used by clients to
@@ -413,20 +454,23 @@ type Message struct {
 
 	// Only set if present and not an IP address. Unicode string. Empty for forwarded
 	// messages.
-	EHLODomain        string         `bstore:"index EHLODomain+Received"`
-	MailFrom          string         // With localpart and domain. Can be empty.
-	MailFromLocalpart smtp.Localpart // SMTP "MAIL FROM", can be empty.
+	EHLODomain string `bstore:"index EHLODomain+Received"`
 	// Only set if it is a domain, not an IP. Unicode string. Empty for forwarded
-	// messages, but see OrigMailFromDomain.
-	MailFromDomain  string         `bstore:"index MailFromDomain+Received"`
-	RcptToLocalpart smtp.Localpart // SMTP "RCPT TO", can be empty.
-	RcptToDomain    string         // Unicode string.
+	// messages, but see OrigMailFromDomain. The full SMTP "MAIL FROM" address is in
+	// Sealed.
+	MailFromDomain string `bstore:"index MailFromDomain+Received"`
 
-	// Parsed "From" message header, used for reputation along with domain validation.
-	MsgFromLocalpart smtp.Localpart
+	// Domain of parsed "From" message header, used for reputation along with domain
+	// validation. The localpart is in Sealed.
 	MsgFromDomain    string `bstore:"index MsgFromDomain+Received"`    // Unicode string.
 	MsgFromOrgDomain string `bstore:"index MsgFromOrgDomain+Received"` // Unicode string.
 
+	// For looking up messages from the same SMTP "MAIL FROM" and "From" message header
+	// address for reputation. Set with PrepareAccount, see AddressKey. Empty if the
+	// domain is empty. Not sent to clients of the web interfaces.
+	MailFromAddressKey string `bstore:"index MailFromAddressKey+Received" json:"-"`
+	MsgFromAddressKey  string `bstore:"index MsgFromAddressKey+Received" json:"-"`
+
 	// Simplified statements of the Validation fields below, used for incoming messages
 	// to check reputation.
 	EHLOValidated     bool
@@ -448,12 +492,14 @@ type Message struct {
 
 	// Canonicalized Message-Id, always lower-case and normalized quoting, without
 	// <>'s. Empty if missing. Used for matching message threads, and to prevent
-	// duplicate reject delivery.
+	// duplicate reject delivery. With encryption at rest, a keyed hash, see
+	// ThreadKey.
 	MessageID string `bstore:"index"`
 	// lower-case: ../rfc/5256:495
 
 	// For matching threads in case there is no References/In-Reply-To header. It is
 	// lower-cased, white-space collapsed, mailing list tags and re/fwd tags removed.
+	// With encryption at rest, a keyed hash, like MessageID.
 	SubjectBase string `bstore:"index"`
 	// ../rfc/5256:90
 
@@ -491,7 +537,8 @@ type Message struct {
 
 	ReceivedTLSVersion     uint16 // 0 if unknown, 1 if plaintext/no TLS, otherwise TLS cipher suite.
 	ReceivedTLSCipherSuite uint16
-	ReceivedRequireTLS     bool // Whether RequireTLS was known to be used for incoming delivery.
+	ReceivedRequireTLS     bool   // Whether RequireTLS was known to be used for incoming delivery.
+	ReceivedTLSFingerprint string // JA4 fingerprint of the TLS client, if known. For recognizing software of senders.
 
 	Flags
 	// For keywords other than system flags or the basic well-known $-flags. Only in
@@ -499,14 +546,53 @@ type Message struct {
 	// (for JMAP), sorted.
 	Keywords    []string `bstore:"index"`
 	Size        int64
-	TrainedJunk *bool  // If nil, no training done yet. Otherwise, true is trained as junk, false trained as nonjunk.
-	MsgPrefix   []byte // Typically holds received headers and/or header separator.
+	TrainedJunk *bool // If nil, no training done yet. Otherwise, true is trained as junk, false trained as nonjunk.
+
+	// Whether the message is also trained in the shared junk filter, as indicated by
+	// TrainedJunk.
+	TrainedShared bool
+
+	// SHA-256 of the contents of the message file, i.e. without MsgPrefix, and
+	// decrypted and decompressed. Set on delivery, for older messages when first
+	// scrubbed. For detecting corruption of message files on disk.
+	Checksum []byte
+
+	// Whether the message file is encrypted. Recorded instead of detected from the
+	// file, because messages can contain arbitrary data. Set on delivery, based on
+	// the configuration at that time.
+	FileEncrypted bool
+
+	// Whether the message file is compressed. Recorded like FileEncrypted.
+	FileCompressed bool
+
+	// Headers, message structure and addresses. Encrypted in the database when
+	// encryption at rest is configured. Not sent to clients of the web interfaces.
+	Sealed MessageSealed `json:"-"`
+}
+
+// MessageSealed holds the fields of a message with headers and addresses. Stored
+// encrypted in the database when encryption at rest is configured, see
+// MarshalBinary.
+type MessageSealed struct {
+	MsgPrefix []byte // Typically holds received headers and/or header separator.
 
 	// ParsedBuf message structure. Currently saved as JSON of message.Part because bstore
 	// cannot yet store recursive types. Created when first needed, and saved in the
 	// database.
 	// todo: once replaced with non-json storage, remove date fixup in ../message/part.go.
 	ParsedBuf []byte
+
+	MailFrom          string         // With localpart and domain. Can be empty.
+	MailFromLocalpart smtp.Localpart // SMTP "MAIL FROM", can be empty.
+	RcptToLocalpart   smtp.Localpart // SMTP "RCPT TO", can be empty.
+	RcptToDomain      string         // Unicode string.
+
+	// Parsed "From" message header, see Message.MsgFromDomain.
+	MsgFromLocalpart smtp.Localpart
+
+	// Name of account the message is stored in, for deriving the encryption keys. Set
+	// with Message.PrepareAccount, and when reading an encrypted value.
+	account string
 }
 
 // MailboxCounts returns the delta to counts this message means for its
@@ -517,6 +603,7 @@ func (m Message) MailboxCounts() (mc MailboxCounts) {
 	}
 	if m.Deleted {
 		mc.Deleted++
+		mc.DeletedSize += m.Size
 	} else {
 		mc.Total++
 	}
@@ -579,9 +666,18 @@ func (m *Message) PrepareExpunge() {
 	}
 }
 
+// PrepareAccount sets the fields of m that depend on the account it is stored in:
+// the account for encrypting m.Sealed, and the address keys, see AddressKey.
+// Must be called again when fields the address keys are based on change.
+func (m *Message) PrepareAccount(accountName string) {
+	m.Sealed.account = accountName
+	m.MailFromAddressKey = AddressKey(accountName, m.Sealed.MailFromLocalpart, m.MailFromDomain)
+	m.MsgFromAddressKey = AddressKey(accountName, m.Sealed.MsgFromLocalpart, m.MsgFromDomain)
+}
+
 // PrepareThreading sets MessageID, SubjectBase and DSN (used in threading) based
-// on the part.
-func (m *Message) PrepareThreading(log mlog.Log, part *message.Part) {
+// on the part. MessageID and SubjectBase are set with ThreadKey for the account.
+func (m *Message) PrepareThreading(log mlog.Log, accountName string, part *message.Part) {
 	m.DSN = part.IsDSN()
 
 	if part.Envelope == nil {
@@ -593,17 +689,45 @@ func (m *Message) PrepareThreading(log mlog.Log, part *message.Part) {
 	} else if raw {
 		log.Debug("could not parse message-id as address, continuing with raw value", slog.String("messageid", part.Envelope.MessageID))
 	}
-	m.MessageID = messageID
-	m.SubjectBase, _ = message.ThreadSubject(part.Envelope.Subject, false)
+	m.MessageID = ThreadKey(accountName, messageID)
+	subjectBase, _ := message.ThreadSubject(part.Envelope.Subject, false)
+	m.SubjectBase = ThreadKey(accountName, subjectBase)
+}
+
+// EnsureParsed returns the parsed message structure for m with msgFile, for an
+// incoming message that is not yet stored. The first call parses the message and
+// stores the structure, with offsets, content types and decoded sizes of parts, in
+// m.Sealed.ParsedBuf. Later calls, e.g. for evaluating rulesets, filter rules and for
+// delivery, reuse it instead of parsing again. After delivery, IMAP and webmail
+// load the structure from the database with LoadPart.
+//
+// Parse errors are logged, the returned part is still usable.
+func (m *Message) EnsureParsed(log mlog.Log, msgFile *os.File) (message.Part, error) {
+	mr := FileMsgReader(m.Sealed.MsgPrefix, msgFile) // We don't close, it would close the msgFile.
+	if m.Sealed.ParsedBuf != nil {
+		return m.LoadPart(mr)
+	}
+
+	p, err := message.EnsurePart(log.Logger, false, mr, m.Size)
+	if err != nil {
+		log.Infox("parsing message", err, slog.String("parse", ""), slog.Int64("message", m.ID))
+		// We continue, p is still valid.
+	}
+	buf, err := json.Marshal(p)
+	if err != nil {
+		return message.Part{}, fmt.Errorf("marshal parsed message: %w", err)
+	}
+	m.Sealed.ParsedBuf = buf
+	return p, nil
 }
 
-// LoadPart returns a message.Part by reading from m.ParsedBuf.
+// LoadPart returns a message.Part by reading from m.Sealed.ParsedBuf.
 func (m Message) LoadPart(r io.ReaderAt) (message.Part, error) {
-	if m.ParsedBuf == nil {
+	if m.Sealed.ParsedBuf == nil {
 		return message.Part{}, fmt.Errorf("message not parsed")
 	}
 	var p message.Part
-	err := json.Unmarshal(m.ParsedBuf, &p)
+	err := json.Unmarshal(m.Sealed.ParsedBuf, &p)
 	if err != nil {
 		return p, fmt.Errorf("unmarshal message part")
 	}
@@ -738,6 +862,14 @@ type Settings struct {
 	// Whether to show the bars underneath the address input fields indicating
 	// starttls/dnssec/dane/mtasts/requiretls support by address.
 	ShowAddressSecurity bool
+
+	// Seconds messages submitted through webmail are held in the queue before delivery
+	// is attempted, during which sending can be undone. Zero disables undo send.
+	SendUndoDelay int
+
+	// Language code for the web interfaces and messages generated by mox, such as
+	// DSNs. Empty for the browser default in web interfaces, and English for messages.
+	Language string
 }
 
 // ViewMode how a message should be viewed: its text parts, html parts, or html
@@ -808,6 +940,23 @@ var DBTypes = []any{
 	RulesetNoListID{},
 	RulesetNoMsgFrom{},
 	RulesetNoMailbox{},
+	ProtocolSession{},
+	AppPassword{},
+	TOTP{},
+	Passkey{},
+	Contact{},
+	CalendarEvent{},
+	FilterRule{},
+	PGPKey{},
+	Upload{},
+	AccountLink{},
+	PushSubscription{},
+	Identity{},
+	RetentionRule{},
+	MigrationMailbox{},
+	MigrationMessage{},
+	SenderListEntry{},
+	Reindex{},
 }
 
 // Account holds the information about a user, includings mailboxes, messages, imap subscriptions.
@@ -830,13 +979,56 @@ type Account struct {
 	// releasing the lock to ensure proper UID ordering.
 	sync.RWMutex
 
+	// Pending deliveries for a group commit, see DeliverGroup.
+	deliverGroup struct {
+		sync.Mutex
+		pending []*deliverRequest
+		busy    bool // Whether a delivery is committing or about to commit pending deliveries.
+	}
+
+	// Message files rewritten in a different format by CompressMessageFiles.
+	// Messages read from the database before the rewrite have outdated FileEncrypted
+	// and FileCompressed fields, so MessageReader uses the format from this map.
+	fileFormats struct {
+		sync.RWMutex
+		m map[int64]fileFormat
+	}
+
+	// Comms registered for changes to this account, see RegisterComm.
+	comms comms
+
+	// UIDs of mailboxes, kept up to date with broadcasted changes, see MailboxUIDs.
+	mailboxUIDs mailboxUIDs
+
 	nused int // Reference count, while >0, this account is alive and shared.
 }
 
 type Upgrade struct {
 	ID      byte
 	Threads byte // 0: None, 1: Adding MessageID's completed, 2: Adding ThreadID's completed.
-}
+
+	// Version of MailboxCounts. When fields are added to MailboxCounts, the version
+	// is increased and counts of all mailboxes are recalculated once when opening
+	// the account. See mailboxCountsVersion.
+	MailboxCounts byte
+
+	// Whether fields of messages with headers and addresses have been moved to
+	// Message.Sealed, and Message.MailFromAddressKey and Message.MsgFromAddressKey
+	// have been set.
+	MessageSealed bool
+	// Whether Message.MessageID and Message.SubjectBase are keyed hashes, see
+	// ThreadKey. Set once when opening the account with encryption at rest.
+	ThreadKeysHashed bool
+	// While upgrading messages for MessageSealed and/or ThreadKeysHashed (if
+	// MessagesHashing is set), the ID of the last upgraded message. See
+	// UpgradeSealed.
+	MessagesLastID  int64
+	MessagesHashing bool
+}
+
+// Current version of MailboxCounts, for Upgrade.MailboxCounts. Version 1 added
+// DeletedSize.
+const mailboxCountsVersion = 1
 
 // InitialUIDValidity returns a UIDValidity used for initializing an account.
 // It can be replaced during tests with a predictable value.
@@ -888,6 +1080,41 @@ func OpenAccount(log mlog.Log, name string) (*Account, error) {
 	return acc, nil
 }
 
+// RemoveAccountData removes the data directory of an account that has already
+// been removed from the configuration, and its web sessions kept in memory. If the
+// account is still open, e.g. by an IMAP connection, an error is returned and the
+// caller should try again later.
+func RemoveAccountData(log mlog.Log, name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\") {
+		return fmt.Errorf("invalid account name %q", name)
+	}
+	if _, ok := mox.Conf.Account(name); ok {
+		return fmt.Errorf("account still present in configuration")
+	}
+
+	// Accounts no longer in the configuration cannot be opened again, so we don't
+	// have to hold the lock while removing. The sessions lock must not be acquired
+	// while holding the lock on open accounts.
+	openAccounts.Lock()
+	_, open := openAccounts.names[name]
+	openAccounts.Unlock()
+	if open {
+		return fmt.Errorf("account still in use")
+	}
+
+	sessions.Lock()
+	delete(sessions.accounts, name)
+	delete(sessions.pendingFlushes, name)
+	sessions.Unlock()
+
+	dir := filepath.Join(mox.DataDirPath("accounts"), name)
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("removing account directory: %v", err)
+	}
+	log.Info("account data removed", slog.String("account", name))
+	return nil
+}
+
 // openAccount opens an existing account, or creates it if it is missing.
 func openAccount(log mlog.Log, name string) (a *Account, rerr error) {
 	dir := filepath.Join(mox.DataDirPath("accounts"), name)
@@ -947,7 +1174,29 @@ func OpenAccountDB(log mlog.Log, accountDir, accountName string) (a *Account, re
 			}
 		}
 
-		err := bstore.QueryTx[Mailbox](tx).FilterEqual("HaveCounts", false).ForEach(func(mb Mailbox) error {
+		// Recalculate counts of all mailboxes if fields were added to MailboxCounts.
+		up := Upgrade{ID: 1}
+		err := tx.Get(&up)
+		absent := err == bstore.ErrAbsent
+		if err != nil && !absent {
+			return fmt.Errorf("get upgrade record: %v", err)
+		}
+		if up.MailboxCounts < mailboxCountsVersion {
+			if _, err := bstore.QueryTx[Mailbox](tx).FilterEqual("HaveCounts", true).UpdateField("HaveCounts", false); err != nil {
+				return fmt.Errorf("marking mailbox counts for recalculation: %v", err)
+			}
+			up.MailboxCounts = mailboxCountsVersion
+			if absent {
+				err = tx.Insert(&up)
+			} else {
+				err = tx.Update(&up)
+			}
+			if err != nil {
+				return fmt.Errorf("storing upgrade record: %v", err)
+			}
+		}
+
+		err = bstore.QueryTx[Mailbox](tx).FilterEqual("HaveCounts", false).ForEach(func(mb Mailbox) error {
 			if !mentioned {
 				mentioned = true
 				log.Info("first calculation of mailbox counts for account", slog.String("account", accountName))
@@ -983,6 +1232,12 @@ func OpenAccountDB(log mlog.Log, accountDir, accountName string) (a *Account, re
 		return nil, fmt.Errorf("calculating counts for mailbox or inserting settings: %v", err)
 	}
 
+	// Must be done before anything else writes messages, which would drop the
+	// fields of messages that haven't been moved to Sealed yet.
+	if err := UpgradeSealed(context.TODO(), log.With(slog.String("account", accountName)), accountName, db); err != nil {
+		return nil, fmt.Errorf("upgrading messages for encryption at rest: %v", err)
+	}
+
 	// Start adding threading if needed.
 	up := Upgrade{ID: 1}
 	err = db.Write(context.TODO(), func(tx *bstore.Tx) error {
@@ -1062,7 +1317,7 @@ func initAccount(db *bstore.DB) error {
 	return db.Write(context.TODO(), func(tx *bstore.Tx) error {
 		uidvalidity := InitialUIDValidity()
 
-		if err := tx.Insert(&Upgrade{ID: 1, Threads: 2}); err != nil {
+		if err := tx.Insert(&Upgrade{ID: 1, Threads: 2, MailboxCounts: mailboxCountsVersion, MessageSealed: true, ThreadKeysHashed: encryptionConfigured()}); err != nil {
 			return err
 		}
 		if err := tx.Insert(&DiskUsage{ID: 1}); err != nil {
@@ -1241,12 +1496,12 @@ func (a *Account) CheckConsistency() error {
 				return nil
 			}
 			p := a.MessagePath(m.ID)
-			st, err := os.Stat(p)
+			fileSize, err := MessageFileSize(p, messageKey(a.Name), m.FileEncrypted, m.FileCompressed)
 			if err != nil {
 				existserr := fmt.Sprintf("message %d in mailbox %q (id %d) on-disk file %s: %v", m.ID, mb.Name, mb.ID, p, err)
 				fileErrors = append(fileErrors, existserr)
-			} else if len(fileErrors) < 20 && m.Size != int64(len(m.MsgPrefix))+st.Size() {
-				sizeerr := fmt.Sprintf("message %d in mailbox %q (id %d) has size %d != len msgprefix %d + on-disk file size %d = %d", m.ID, mb.Name, mb.ID, m.Size, len(m.MsgPrefix), st.Size(), int64(len(m.MsgPrefix))+st.Size())
+			} else if len(fileErrors) < 20 && m.Size != int64(len(m.Sealed.MsgPrefix))+fileSize {
+				sizeerr := fmt.Sprintf("message %d in mailbox %q (id %d) has size %d != len msgprefix %d + on-disk file size %d = %d", m.ID, mb.Name, mb.ID, m.Size, len(m.Sealed.MsgPrefix), fileSize, int64(len(m.Sealed.MsgPrefix))+fileSize)
 				fileErrors = append(fileErrors, sizeerr)
 			}
 
@@ -1321,6 +1576,15 @@ func (a *Account) Conf() (config.Account, bool) {
 	return mox.Conf.Account(a.Name)
 }
 
+// compressMessages returns whether new message files must be compressed.
+func (a *Account) compressMessages() bool {
+	if mox.Conf.Static.CompressMessages {
+		return true
+	}
+	conf, _ := a.Conf()
+	return conf.CompressMessages
+}
+
 // NextUIDValidity returns the next new/unique uidvalidity to use for this account.
 func (a *Account) NextUIDValidity(tx *bstore.Tx) (uint32, error) {
 	nuv := NextUIDValidity{ID: 1}
@@ -1363,25 +1627,29 @@ func (a *Account) HighestDeletedModSeq(tx *bstore.Tx) (ModSeq, error) {
 }
 
 // WithWLock runs fn with account writelock held. Necessary for account/mailbox
-// modification. For message delivery, a read lock is required.
+// modification. For message delivery, a read lock is required. After unlocking,
+// it waits for Comms that have too many changes pending after broadcasts by fn.
 func (a *Account) WithWLock(fn func()) {
 	a.Lock()
+	defer a.waitComms()
 	defer a.Unlock()
 	fn()
 }
 
 // WithRLock runs fn with account read lock held. Needed for message delivery.
+// Like WithWLock, it waits for Comms with too many changes pending after unlocking.
 func (a *Account) WithRLock(fn func()) {
 	a.RLock()
+	defer a.waitComms()
 	defer a.RUnlock()
 	fn()
 }
 
 // DeliverMessage delivers a mail message to the account.
 //
-// The message, with msg.MsgPrefix and msgFile combined, must have a header
+// The message, with msg.Sealed.MsgPrefix and msgFile combined, must have a header
 // section. The caller is responsible for adding a header separator to
-// msg.MsgPrefix if missing from an incoming message.
+// msg.Sealed.MsgPrefix if missing from an incoming message.
 //
 // If the destination mailbox has the Sent special-use flag, the message is parsed
 // for its recipients (to/cc/bcc). Their domains are added to Recipients for use in
@@ -1430,33 +1698,25 @@ func (a *Account) DeliverMessage(log mlog.Log, tx *bstore.Tx, m *Message, msgFil
 	conf, _ := a.Conf()
 	m.JunkFlagsForMailbox(mb, conf)
 
-	mr := FileMsgReader(m.MsgPrefix, msgFile) // We don't close, it would close the msgFile.
 	var part *message.Part
-	if m.ParsedBuf == nil {
-		p, err := message.EnsurePart(log.Logger, false, mr, m.Size)
-		if err != nil {
-			log.Infox("parsing delivered message", err, slog.String("parse", ""), slog.Int64("message", m.ID))
-			// We continue, p is still valid.
-		}
-		part = &p
-		buf, err := json.Marshal(part)
-		if err != nil {
-			return fmt.Errorf("marshal parsed message: %w", err)
-		}
-		m.ParsedBuf = buf
+	if p, err := m.EnsureParsed(log, msgFile); err != nil {
+		log.Errorx("loading parsed message, continuing", err, slog.String("parse", ""))
 	} else {
-		var p message.Part
-		if err := json.Unmarshal(m.ParsedBuf, &p); err != nil {
-			log.Errorx("unmarshal parsed message, continuing", err, slog.String("parse", ""))
-		} else {
-			part = &p
-		}
+		part = &p
 	}
 
 	// If we are delivering to the originally intended mailbox, no need to store the mailbox ID again.
 	if m.MailboxDestinedID != 0 && m.MailboxDestinedID == m.MailboxOrigID {
 		m.MailboxDestinedID = 0
 	}
+	if m.Checksum == nil {
+		h := sha256.New()
+		if _, err := io.Copy(h, &moxio.AtReader{R: msgFile}); err != nil {
+			return fmt.Errorf("checksum of message file: %w", err)
+		}
+		m.Checksum = h.Sum(nil)
+	}
+
 	if m.CreateSeq == 0 || m.ModSeq == 0 {
 		modseq, err := a.NextModSeq(tx)
 		if err != nil {
@@ -1466,8 +1726,9 @@ func (a *Account) DeliverMessage(log mlog.Log, tx *bstore.Tx, m *Message, msgFil
 		m.ModSeq = modseq
 	}
 
+	m.PrepareAccount(a.Name)
 	if part != nil && m.MessageID == "" && m.SubjectBase == "" {
-		m.PrepareThreading(log, part)
+		m.PrepareThreading(log, a.Name, part)
 	}
 
 	// Assign to thread (if upgrade has completed).
@@ -1479,7 +1740,7 @@ func (a *Account) DeliverMessage(log mlog.Log, tx *bstore.Tx, m *Message, msgFil
 				log.Info("not assigning threads for new delivery, upgrading to threads failed")
 				noThreadID = true
 			} else {
-				if err := assignThread(log, tx, m, part); err != nil {
+				if err := assignThread(log, tx, a.Name, m, part); err != nil {
 					return fmt.Errorf("assigning thread: %w", err)
 				}
 			}
@@ -1492,6 +1753,8 @@ func (a *Account) DeliverMessage(log mlog.Log, tx *bstore.Tx, m *Message, msgFil
 		}
 	}
 
+	m.FileEncrypted = messageKey(a.Name) != nil
+	m.FileCompressed = a.compressMessages()
 	if err := tx.Insert(m); err != nil {
 		return fmt.Errorf("inserting message: %w", err)
 	}
@@ -1539,6 +1802,20 @@ func (a *Account) DeliverMessage(log mlog.Log, tx *bstore.Tx, m *Message, msgFil
 			if err := tx.Insert(&mr); err != nil {
 				return fmt.Errorf("inserting sent message recipients: %w", err)
 			}
+			if err := contactHarvest(log, tx, addr.Name, lp, d); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Learn OpenPGP keys of correspondents from Autocrypt headers.
+	if !mb.Sent && !mb.Junk && !m.Junk && part != nil && part.Envelope != nil {
+		date := part.Envelope.Date
+		if date.IsZero() || date.After(m.Received) {
+			date = m.Received
+		}
+		if err := autocryptUpdate(log, tx, part, date); err != nil {
+			return err
 		}
 	}
 
@@ -1553,10 +1830,19 @@ func (a *Account) DeliverMessage(log mlog.Log, tx *bstore.Tx, m *Message, msgFil
 		}
 	}
 
-	if err := moxio.LinkOrCopy(log, msgPath, msgFile.Name(), &moxio.AtReader{R: msgFile}, true); err != nil {
+	if m.FileEncrypted || m.FileCompressed {
+		if err := writeMessageFile(msgPath, &moxio.AtReader{R: msgFile}, messageKey(a.Name), m.FileCompressed, true); err != nil {
+			return fmt.Errorf("writing message file: %w", err)
+		}
+	} else if err := moxio.LinkOrCopy(log, msgPath, msgFile.Name(), &moxio.AtReader{R: msgFile}, true); err != nil {
 		return fmt.Errorf("linking/copying message to new file: %w", err)
 	}
 
+	if mox.Conf.Static.DeduplicateMessages {
+		_, _, err := dedupMessageFile(log, msgPath, m.FileEncrypted)
+		log.Check(err, "deduplicating message file, continuing", slog.String("path", msgPath))
+	}
+
 	if sync {
 		if err := moxio.SyncDir(log, msgDir); err != nil {
 			xerr := os.Remove(msgPath)
@@ -1636,6 +1922,10 @@ func (a *Account) SetPassword(log mlog.Log, password string) error {
 		pw.SCRAMSHA256.Iterations = 4096
 		pw.SCRAMSHA256.SaltedPassword = scram.SaltPassword(sha256.New, password, pw.SCRAMSHA256.Salt, pw.SCRAMSHA256.Iterations)
 
+		pw.SCRAMSHA512.Salt = scram.MakeRandom()
+		pw.SCRAMSHA512.Iterations = 10000
+		pw.SCRAMSHA512.SaltedPassword = scram.SaltPassword(sha512.New, password, pw.SCRAMSHA512.Salt, pw.SCRAMSHA512.Iterations)
+
 		if err := tx.Insert(&pw); err != nil {
 			return fmt.Errorf("inserting new password: %v", err)
 		}
@@ -1648,6 +1938,44 @@ func (a *Account) SetPassword(log mlog.Log, password string) error {
 	return err
 }
 
+// ErrNoPassword is returned when requiring a password change for an account
+// without password.
+var ErrNoPassword = errors.New("account has no password")
+
+// PasswordChangeRequired returns whether an admin requires the user to change
+// the password of the account.
+func (a *Account) PasswordChangeRequired(ctx context.Context) (bool, error) {
+	pw, err := bstore.QueryDB[Password](ctx, a.DB).Get()
+	if err == bstore.ErrAbsent {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return pw.ChangeRequired, nil
+}
+
+// PasswordChangeRequire sets whether the user must change the password of the
+// account. When requiring a change, all login sessions of the account are
+// removed. Returns ErrNoPassword if the account has no password.
+func (a *Account) PasswordChangeRequire(ctx context.Context, log mlog.Log, required bool) error {
+	return a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		pw, err := bstore.QueryTx[Password](tx).Get()
+		if err == bstore.ErrAbsent {
+			return ErrNoPassword
+		} else if err != nil {
+			return err
+		}
+		pw.ChangeRequired = required
+		if err := tx.Update(&pw); err != nil {
+			return fmt.Errorf("updating password: %v", err)
+		}
+		if !required {
+			return nil
+		}
+		return sessionRemoveAll(ctx, log, tx, a.Name)
+	})
+}
+
 // Subjectpass returns the signing key for use with subjectpass for the given
 // email address with canonical localpart.
 func (a *Account) Subjectpass(email string) (key string, err error) {
@@ -1793,19 +2121,18 @@ func (a *Account) SubscriptionEnsure(tx *bstore.Tx, name string) ([]Change, erro
 }
 
 // MessageRuleset returns the first ruleset (if any) that matches the message
-// represented by msgPrefix and msgFile, with smtp and validation fields from m.
-func MessageRuleset(log mlog.Log, dest config.Destination, m *Message, msgPrefix []byte, msgFile *os.File) *config.Ruleset {
+// represented by m.Sealed.MsgPrefix and msgFile, with smtp and validation fields from m.
+// The parsed message is kept in m.Sealed.ParsedBuf, for reuse during delivery.
+func MessageRuleset(log mlog.Log, dest config.Destination, m *Message, msgFile *os.File) *config.Ruleset {
 	if len(dest.Rulesets) == 0 {
 		return nil
 	}
 
-	mr := FileMsgReader(msgPrefix, msgFile) // We don't close, it would close the msgFile.
-	p, err := message.Parse(log.Logger, false, mr)
+	p, err := m.EnsureParsed(log, msgFile)
 	if err != nil {
-		log.Errorx("parsing message for evaluating rulesets, continuing with headers", err, slog.String("parse", ""))
-		// note: part is still set.
+		log.Errorx("parsing message for evaluating rulesets, delivering to default mailbox", err, slog.String("parse", ""))
+		return nil
 	}
-	// todo optimize: only parse header if needed for rulesets. and probably reuse an earlier parsing.
 	header, err := p.Header()
 	if err != nil {
 		log.Errorx("parsing message headers for evaluating rulesets, delivering to default mailbox", err, slog.String("parse", ""))
@@ -1816,12 +2143,12 @@ func MessageRuleset(log mlog.Log, dest config.Destination, m *Message, msgPrefix
 ruleset:
 	for _, rs := range dest.Rulesets {
 		if rs.SMTPMailFromRegexpCompiled != nil {
-			if !rs.SMTPMailFromRegexpCompiled.MatchString(m.MailFrom) {
+			if !rs.SMTPMailFromRegexpCompiled.MatchString(m.Sealed.MailFrom) {
 				continue ruleset
 			}
 		}
 		if rs.MsgFromRegexpCompiled != nil {
-			if m.MsgFromLocalpart == "" && m.MsgFromDomain == "" || !rs.MsgFromRegexpCompiled.MatchString(m.MsgFromLocalpart.String()+"@"+m.MsgFromDomain) {
+			if m.Sealed.MsgFromLocalpart == "" && m.MsgFromDomain == "" || !rs.MsgFromRegexpCompiled.MatchString(m.Sealed.MsgFromLocalpart.String()+"@"+m.MsgFromDomain) {
 				continue ruleset
 			}
 		}
@@ -1877,9 +2204,10 @@ func (a *Account) MessagePath(messageID int64) string {
 }
 
 // MessageReader opens a message for reading, transparently combining the
-// message prefix with the original incoming message.
+// message prefix with the original incoming message, and decrypting the on-disk
+// message file if it is encrypted.
 func (a *Account) MessageReader(m Message) *MsgReader {
-	return &MsgReader{prefix: m.MsgPrefix, path: a.MessagePath(m.ID), size: m.Size}
+	return &MsgReader{prefix: m.Sealed.MsgPrefix, path: a.MessagePath(m.ID), key: messageKey(a.Name), encrypted: m.FileEncrypted, compressed: m.FileCompressed, size: m.Size, acc: a, id: m.ID}
 }
 
 // DeliverDestination delivers an email to dest, based on the configured rulesets.
@@ -1891,7 +2219,7 @@ func (a *Account) MessageReader(m Message) *MsgReader {
 // broadcasted.
 func (a *Account) DeliverDestination(log mlog.Log, dest config.Destination, m *Message, msgFile *os.File) error {
 	var mailbox string
-	rs := MessageRuleset(log, dest, m, m.MsgPrefix, msgFile)
+	rs := MessageRuleset(log, dest, m, msgFile)
 	if rs != nil {
 		mailbox = rs.Mailbox
 	} else if dest.Mailbox == "" {
@@ -1910,35 +2238,15 @@ func (a *Account) DeliverDestination(log mlog.Log, dest config.Destination, m *M
 // Message delivery, possible mailbox creation, and updated mailbox counts are
 // broadcasted.
 func (a *Account) DeliverMailbox(log mlog.Log, mailbox string, m *Message, msgFile *os.File) error {
+	return a.deliverMailbox(log, mailbox, m, msgFile, true)
+}
+
+func (a *Account) deliverMailbox(log mlog.Log, mailbox string, m *Message, msgFile *os.File, sync bool) error {
 	var changes []Change
 	err := a.DB.Write(context.TODO(), func(tx *bstore.Tx) error {
-		if ok, _, err := a.CanAddMessageSize(tx, m.Size); err != nil {
-			return err
-		} else if !ok {
-			return ErrOverQuota
-		}
-
-		mb, chl, err := a.MailboxEnsure(tx, mailbox, true)
-		if err != nil {
-			return fmt.Errorf("ensuring mailbox: %w", err)
-		}
-		m.MailboxID = mb.ID
-		m.MailboxOrigID = mb.ID
-
-		// Update count early, DeliverMessage will update mb too and we don't want to fetch
-		// it again before updating.
-		mb.MailboxCounts.Add(m.MailboxCounts())
-		if err := tx.Update(&mb); err != nil {
-			return fmt.Errorf("updating mailbox for delivery: %w", err)
-		}
-
-		if err := a.DeliverMessage(log, tx, m, msgFile, true, false, false, true); err != nil {
-			return err
-		}
-
-		changes = append(changes, chl...)
-		changes = append(changes, m.ChangeAddUID(), mb.ChangeCounts())
-		return nil
+		chl, err := a.deliverMailboxTx(log, tx, mailbox, m, msgFile, sync, false)
+		changes = chl
+		return err
 	})
 	// todo: if rename succeeded but transaction failed, we should remove the file.
 	if err != nil {
@@ -1949,6 +2257,43 @@ func (a *Account) DeliverMailbox(log mlog.Log, mailbox string, m *Message, msgFi
 	return nil
 }
 
+// deliverMailboxTx delivers m to mailbox in tx, ensuring the mailbox exists and
+// updating its counts. The changes to broadcast after committing are returned.
+func (a *Account) deliverMailboxTx(log mlog.Log, tx *bstore.Tx, mailbox string, m *Message, msgFile *os.File, sync, notrain bool) ([]Change, error) {
+	if ok, _, err := a.CanAddMessageSize(tx, m.Size); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, ErrOverQuota
+	}
+
+	mb, changes, err := a.MailboxEnsure(tx, mailbox, true)
+	if err != nil {
+		return nil, fmt.Errorf("ensuring mailbox: %w", err)
+	}
+	m.MailboxID = mb.ID
+	m.MailboxOrigID = mb.ID
+
+	// Update count early, DeliverMessage will update mb too and we don't want to fetch
+	// it again before updating.
+	mb.MailboxCounts.Add(m.MailboxCounts())
+	// Keywords can be set on incoming messages by filter rules.
+	var mbKwChanged bool
+	mb.Keywords, mbKwChanged = MergeKeywords(mb.Keywords, m.Keywords)
+	if err := tx.Update(&mb); err != nil {
+		return nil, fmt.Errorf("updating mailbox for delivery: %w", err)
+	}
+
+	if err := a.DeliverMessage(log, tx, m, msgFile, sync, notrain, false, true); err != nil {
+		return nil, err
+	}
+
+	if mbKwChanged {
+		changes = append(changes, mb.ChangeKeywords())
+	}
+	changes = append(changes, m.ChangeAddUID(), mb.ChangeCounts())
+	return changes, nil
+}
+
 // TidyRejectsMailbox removes old reject emails, and returns whether there is space for a new delivery.
 //
 // Caller most hold account wlock.
@@ -2080,6 +2425,7 @@ func (a *Account) rejectsRemoveMessages(ctx context.Context, log mlog.Log, tx *b
 }
 
 // RejectsRemove removes a message from the rejects mailbox if present.
+// MessageID is as in Message.MessageID, see ThreadKey.
 // Caller most hold account wlock.
 // Changes are broadcasted.
 func (a *Account) RejectsRemove(log mlog.Log, rejectsMailbox, messageID string) error {
@@ -2203,12 +2549,31 @@ func manageAuthCache() {
 //
 // The email address may contain a catchall separator.
 func OpenEmailAuth(log mlog.Log, email string, password string) (acc *Account, rerr error) {
+	return openEmailAuth(log, email, password, "", nil)
+}
+
+// OpenEmailAuthProtocol opens an account given an email address and password,
+// for authentication with IMAP or SMTP submission (protocol "imap" or "smtp").
+// Besides the account password, app passwords that are allowed for the protocol
+// and remote IP are accepted.
+//
+// The email address may contain a catchall separator.
+func OpenEmailAuthProtocol(log mlog.Log, email string, password string, protocol string, remoteIP net.IP) (acc *Account, rerr error) {
+	return openEmailAuth(log, email, password, protocol, remoteIP)
+}
+
+func openEmailAuth(log mlog.Log, email string, password string, protocol string, remoteIP net.IP) (acc *Account, rerr error) {
 	password, err := precis.OpaqueString.String(password)
 	if err != nil {
 		return nil, ErrUnknownCredentials
 	}
 
+	addr, auth := ExternalAuth(email)
+
 	acc, _, rerr = OpenEmail(log, email)
+	if rerr != nil && errors.Is(rerr, ErrUnknownCredentials) && auth != nil && auth.AutoProvision {
+		acc, rerr = autoProvision(log, addr, *auth, password)
+	}
 	if rerr != nil {
 		return
 	}
@@ -2221,27 +2586,219 @@ func OpenEmailAuth(log mlog.Log, email string, password string) (acc *Account, r
 		}
 	}()
 
-	pw, err := bstore.QueryDB[Password](context.TODO(), acc.DB).Get()
-	if err != nil {
-		if err == bstore.ErrAbsent {
+	var ok, changeRequired bool
+	if auth != nil {
+		ok, err = externalAuthVerify(log, addr, *auth, password)
+		if err != nil {
+			return acc, fmt.Errorf("external authentication: %w", err)
+		}
+	} else {
+		pw, err := bstore.QueryDB[Password](context.TODO(), acc.DB).Get()
+		if err != nil && err != bstore.ErrAbsent {
+			return acc, fmt.Errorf("looking up password: %v", err)
+		} else if err == nil {
+			changeRequired = pw.ChangeRequired
+			authCache.Lock()
+			ok = len(password) >= 8 && authCache.success[authKey{email, pw.Hash}] == password
+			authCache.Unlock()
+			if !ok {
+				ok = bcrypt.CompareHashAndPassword([]byte(pw.Hash), []byte(password)) == nil
+				if ok {
+					authCache.Lock()
+					authCache.success[authKey{email, pw.Hash}] = password
+					authCache.Unlock()
+				}
+			}
+		}
+	}
+	if ok && protocol != "" && changeRequired {
+		// The password must first be changed in the account web interface. App passwords
+		// can still be used.
+		log.Info("account password not accepted, password change is required", slog.String("protocol", protocol))
+		return acc, ErrUnknownCredentials
+	}
+	if ok && protocol != "" {
+		// With two-factor authentication, the account password is only for the web
+		// interfaces, IMAP and SMTP must use app passwords.
+		var totpEnabled bool
+		err := acc.DB.Read(context.TODO(), func(tx *bstore.Tx) error {
+			var err error
+			totpEnabled, err = TOTPEnabledTx(tx)
+			return err
+		})
+		if err != nil {
+			return acc, fmt.Errorf("checking two-factor authentication: %v", err)
+		} else if totpEnabled {
+			log.Info("account password not accepted, two-factor authentication is enabled, app passwords must be used", slog.String("protocol", protocol))
 			return acc, ErrUnknownCredentials
 		}
-		return acc, fmt.Errorf("looking up password: %v", err)
 	}
+	if ok {
+		return acc, CheckLoginDisabled(acc.Name)
+	}
+	// Try app passwords, for IMAP/SMTP only. Also when the account has no password set.
+	if protocol == "" {
+		return acc, ErrUnknownCredentials
+	}
+	if err := acc.appPasswordAuth(log, email, password, protocol, remoteIP); err != nil {
+		return acc, err
+	}
+	return acc, CheckLoginDisabled(acc.Name)
+}
+
+// ExternalAuth returns the login address, with catchall separator and case
+// canonicalized, and the external authentication configuration for its domain, if
+// any. For addresses in domains without external authentication, auth is nil.
+func ExternalAuth(email string) (addr smtp.Address, auth *config.DomainAuth) {
+	addr, err := smtp.ParseAddress(email)
+	if err != nil {
+		return addr, nil
+	}
+	dc, ok := mox.Conf.Domain(addr.Domain)
+	if !ok || dc.Auth == nil {
+		return addr, nil
+	}
+	return smtp.NewAddress(mox.CanonicalLocalpart(addr.Localpart, dc), addr.Domain), dc.Auth
+}
+
+// externalAuthVerify verifies a password with the external authentication
+// backend, using the cache of recent successful authentications. Passwords
+// changed or disabled in the backend are accepted until the cache is cleared.
+func externalAuthVerify(log mlog.Log, addr smtp.Address, auth config.DomainAuth, password string) (bool, error) {
+	key := authKey{addr.String(), "extauth"}
 	authCache.Lock()
-	ok := len(password) >= 8 && authCache.success[authKey{email, pw.Hash}] == password
+	ok := password != "" && authCache.success[key] == password
 	authCache.Unlock()
 	if ok {
-		return
+		return true, nil
 	}
-	if err := bcrypt.CompareHashAndPassword([]byte(pw.Hash), []byte(password)); err != nil {
-		rerr = ErrUnknownCredentials
-	} else {
+	ok, err := extauth.Verify(context.TODO(), log, auth, addr, password)
+	if ok {
 		authCache.Lock()
-		authCache.success[authKey{email, pw.Hash}] = password
+		authCache.success[key] = password
 		authCache.Unlock()
 	}
-	return
+	return ok, err
+}
+
+// autoProvision creates an account for an address that is not yet configured,
+// in a domain with external authentication, after verifying the password.
+func autoProvision(log mlog.Log, addr smtp.Address, auth config.DomainAuth, password string) (*Account, error) {
+	ok, err := externalAuthVerify(log, addr, auth, password)
+	if err != nil {
+		return nil, fmt.Errorf("external authentication: %w", err)
+	} else if !ok {
+		return nil, ErrUnknownCredentials
+	}
+
+	accountName := addr.Localpart.String()
+	if _, exists := mox.Conf.Account(accountName); exists {
+		accountName = addr.String()
+	}
+	if err := mox.AccountAdd(context.TODO(), accountName, addr.String()); err != nil {
+		return nil, fmt.Errorf("adding account for external authentication: %w", err)
+	}
+	log.Info("account created after external authentication", slog.String("account", accountName), slog.Any("address", addr))
+	return OpenAccount(log, accountName)
+}
+
+// OpenEmailToken opens an account for an OAuth 2.0 access token issued by the
+// configured OpenID Connect identity provider, for authentication with IMAP or
+// SMTP submission. If email is not empty, it must be the address the token was
+// issued for. The address from the token is returned as login address. Invalid
+// tokens result in ErrUnknownCredentials.
+func OpenEmailToken(log mlog.Log, token, email string) (acc *Account, loginAddress string, rerr error) {
+	p := oidc.Get(mox.Conf.Static.OIDC)
+	if p == nil {
+		return nil, "", ErrUnknownCredentials
+	}
+	ctx, cancel := context.WithTimeout(context.TODO(), 30*time.Second)
+	defer cancel()
+	loginAddress, err := p.VerifyAccessToken(ctx, log, token)
+	if err != nil && errors.Is(err, oidc.ErrToken) {
+		log.Infox("access token not accepted", err)
+		return nil, "", ErrUnknownCredentials
+	} else if err != nil {
+		return nil, "", fmt.Errorf("verifying access token: %w", err)
+	}
+	if email != "" {
+		a, err := smtp.ParseAddress(email)
+		b, xerr := smtp.ParseAddress(loginAddress)
+		if err != nil || xerr != nil || !strings.EqualFold(a.String(), b.String()) {
+			log.Info("access token for other address", slog.String("username", email), slog.String("token", loginAddress))
+			return nil, "", ErrUnknownCredentials
+		}
+	}
+	acc, _, err = OpenEmailLogin(log, loginAddress)
+	if err != nil {
+		return nil, "", err
+	}
+	return acc, loginAddress, nil
+}
+
+// TLSClientCertIdentities returns the e-mail addresses a TLS client certificate
+// can authenticate as: the e-mail addresses in the subject alternative names, and
+// the common name of the subject if it is an e-mail address.
+func TLSClientCertIdentities(cert *x509.Certificate) []string {
+	l := append([]string{}, cert.EmailAddresses...)
+	if _, err := smtp.ParseAddress(cert.Subject.CommonName); err == nil {
+		l = append(l, cert.Subject.CommonName)
+	}
+	return l
+}
+
+// OpenTLSClientCert opens an account for a verified TLS client certificate, for
+// authentication with SASL EXTERNAL for IMAP or SMTP submission. If authz is not
+// empty, it must be one of the identities of the certificate. Otherwise, the
+// first identity that is an address of an account is used. The login address is
+// returned. Certificates without matching address result in
+// ErrUnknownCredentials.
+func OpenTLSClientCert(log mlog.Log, cert *x509.Certificate, authz string) (acc *Account, loginAddress string, rerr error) {
+	ids := TLSClientCertIdentities(cert)
+	if authz != "" {
+		a, err := smtp.ParseAddress(authz)
+		if err != nil {
+			return nil, "", ErrUnknownCredentials
+		}
+		var match bool
+		for _, id := range ids {
+			if b, err := smtp.ParseAddress(id); err == nil && strings.EqualFold(a.String(), b.String()) {
+				match = true
+				break
+			}
+		}
+		if !match {
+			log.Info("client certificate not valid for requested identity", slog.String("username", authz), slog.Any("identities", ids))
+			return nil, "", ErrUnknownCredentials
+		}
+		ids = []string{authz}
+	}
+	for _, id := range ids {
+		acc, _, err := OpenEmailLogin(log, id)
+		if err == nil {
+			return acc, id, nil
+		} else if !errors.Is(err, ErrUnknownCredentials) {
+			return nil, "", err
+		}
+	}
+	return nil, "", ErrUnknownCredentials
+}
+
+// OpenEmailLogin opens an account given an email address, for a login that was
+// authenticated by other means than a password, e.g. with a TLS client
+// certificate, SCRAM or a passkey. An error matching ErrLoginDisabled is returned
+// if logins are disabled for the account.
+func OpenEmailLogin(log mlog.Log, email string) (*Account, config.Destination, error) {
+	acc, dest, err := OpenEmail(log, email)
+	if err != nil {
+		return nil, dest, err
+	}
+	if err := CheckLoginDisabled(acc.Name); err != nil {
+		xerr := acc.Close()
+		log.Check(xerr, "closing account")
+		return nil, dest, err
+	}
+	return acc, dest, nil
 }
 
 // OpenEmail opens an account given an email address.
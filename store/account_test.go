@@ -56,9 +56,9 @@ func TestMailbox(t *testing.T) {
 	msgPrefix := []byte("From: <mjl@mox.example\r\nTo: <mjl@mox.example>\r\nCc: <mjl@mox.example>Subject: test\r\nMessage-Id: <m01@mox.example>\r\n\r\n")
 	msgPrefixCatchall := []byte("Subject: catchall\r\n\r\n")
 	m := Message{
-		Received:  time.Now(),
-		Size:      int64(len(msgPrefix)) + msgWriter.Size,
-		MsgPrefix: msgPrefix,
+		Received: time.Now(),
+		Size:     int64(len(msgPrefix)) + msgWriter.Size,
+		Sealed:   MessageSealed{MsgPrefix: msgPrefix},
 	}
 	msent := m
 	m.ThreadMuted = true
@@ -67,9 +67,9 @@ func TestMailbox(t *testing.T) {
 	mbrejects := Mailbox{Name: "Rejects", UIDValidity: 1, UIDNext: 1, HaveCounts: true}
 	mreject := m
 	mconsumed := Message{
-		Received:  m.Received,
-		Size:      int64(len(msgPrefixCatchall)) + msgWriter.Size,
-		MsgPrefix: msgPrefixCatchall,
+		Received: m.Received,
+		Size:     int64(len(msgPrefixCatchall)) + msgWriter.Size,
+		Sealed:   MessageSealed{MsgPrefix: msgPrefixCatchall},
 	}
 	acc.WithWLock(func() {
 		conf, _ := acc.Conf()
@@ -145,6 +145,21 @@ func TestMailbox(t *testing.T) {
 	err = acc.SetPassword(log, "testtest")
 	tcheck(t, err, "set password")
 
+	err = acc.PasswordChangeRequire(ctxbg, log, true)
+	tcheck(t, err, "require password change")
+	required, err := acc.PasswordChangeRequired(ctxbg)
+	tcheck(t, err, "password change required")
+	if !required {
+		t.Fatalf("password change not required after requiring")
+	}
+	err = acc.SetPassword(log, "testtest")
+	tcheck(t, err, "set password")
+	required, err = acc.PasswordChangeRequired(ctxbg)
+	tcheck(t, err, "password change required")
+	if required {
+		t.Fatalf("password change still required after setting new password")
+	}
+
 	key0, err := acc.Subjectpass("test@localhost")
 	tcheck(t, err, "subjectpass")
 	key1, err := acc.Subjectpass("test@localhost")
@@ -285,19 +300,89 @@ Rulesets:
 	}
 	dest.Rulesets[0].HeadersRegexpCompiled = hdrs
 
-	c := MessageRuleset(pkglog, dest, &Message{}, msgBuf, f)
+	m := &Message{Size: int64(len(msgBuf)), Sealed: MessageSealed{MsgPrefix: msgBuf}}
+	c := MessageRuleset(pkglog, dest, m, f)
 	if c == nil {
 		t.Fatalf("expected ruleset match")
 	}
+	// Parsed message is kept, and reused for the next evaluation.
+	if m.Sealed.ParsedBuf == nil {
+		t.Fatalf("parsed message not kept")
+	}
+	c = MessageRuleset(pkglog, dest, m, f)
+	if c == nil {
+		t.Fatalf("expected ruleset match with kept parsed message")
+	}
 
 	msg2Buf := []byte(strings.ReplaceAll(`From: <mjl@mox.example>
 
 test
 `, "\n", "\r\n"))
-	c = MessageRuleset(pkglog, dest, &Message{}, msg2Buf, f)
+	c = MessageRuleset(pkglog, dest, &Message{Size: int64(len(msg2Buf)), Sealed: MessageSealed{MsgPrefix: msg2Buf}}, f)
 	if c != nil {
 		t.Fatalf("expected no ruleset match")
 	}
 
 	// todo: test the SMTPMailFrom and VerifiedDomains rule.
 }
+
+func TestMailboxCountsUpgrade(t *testing.T) {
+	log := pkglog
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	defer Switchboard()()
+
+	acc, err := OpenAccount(log, "mjl")
+	tcheck(t, err, "open account")
+
+	const msg = "Subject: test\r\n\r\nbody\r\n"
+	msgFile, err := CreateMessageTemp(log, "account-test")
+	tcheck(t, err, "create temp message")
+	defer os.Remove(msgFile.Name())
+	defer msgFile.Close()
+	_, err = msgFile.Write([]byte(msg))
+	tcheck(t, err, "write message")
+	m := Message{Received: time.Now(), Size: int64(len(msg)), Flags: Flags{Deleted: true}}
+	acc.WithWLock(func() {
+		err = acc.DeliverMailbox(log, "Inbox", &m, msgFile)
+	})
+	tcheck(t, err, "deliver message")
+
+	inbox := func() Mailbox {
+		mb, err := bstore.QueryDB[Mailbox](ctxbg, acc.DB).FilterNonzero(Mailbox{Name: "Inbox"}).Get()
+		tcheck(t, err, "get inbox")
+		return mb
+	}
+	mb := inbox()
+	tcompare(t, mb.DeletedSize, m.Size)
+
+	err = acc.Close()
+	tcheck(t, err, "close account")
+	acc.CheckClosed()
+
+	// Revert to counts from before DeletedSize was added.
+	db, err := bstore.Open(ctxbg, acc.DBPath, nil, DBTypes...)
+	tcheck(t, err, "open database")
+	mb.DeletedSize = 0
+	err = db.Update(ctxbg, &mb)
+	tcheck(t, err, "update mailbox")
+	err = db.Update(ctxbg, &Upgrade{ID: 1, Threads: 2})
+	tcheck(t, err, "update upgrade record")
+	err = db.Close()
+	tcheck(t, err, "close database")
+
+	// Counts are recalculated when opening.
+	acc, err = OpenAccount(log, "mjl")
+	tcheck(t, err, "open account")
+	defer func() {
+		err = acc.Close()
+		tcheck(t, err, "closing account")
+		acc.CheckClosed()
+	}()
+	tcompare(t, inbox().DeletedSize, m.Size)
+	up := Upgrade{ID: 1}
+	err = acc.DB.Get(ctxbg, &up)
+	tcheck(t, err, "get upgrade record")
+	tcompare(t, up.MailboxCounts, byte(mailboxCountsVersion))
+}
@@ -0,0 +1,147 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+)
+
+// ErrAccountLinkInvalid is returned when opening a linked account whose
+// credentials changed since it was linked.
+var ErrAccountLinkInvalid = errors.New("credentials of linked account changed, link the account again")
+
+func init() {
+	mox.AccountRemoved = accountLinksRemove
+}
+
+// AccountLink gives sessions of an account access to another account, e.g. a
+// shared role account, for using multiple accounts from a single webmail session.
+// Links are added after verifying the credentials of the other account, and remain
+// until removed, or until the linked account is removed. A link stops working when
+// the password or two-factor authentication of the linked account changes, or when
+// logins to the linked account are disabled.
+type AccountLink struct {
+	ID           int64
+	Created      time.Time `bstore:"nonzero,default now"`
+	Account      string    `bstore:"nonzero,unique"` // Name of the linked account.
+	LoginAddress string    `bstore:"nonzero"`        // Address used to authenticate when linking, used as login address for the linked account.
+	Credentials  string    // Fingerprint of the credentials of the linked account when linking, see CredentialsFingerprint.
+}
+
+// CredentialsFingerprint returns a fingerprint of the password and two-factor
+// authentication secret of the account. It changes when the password is changed or
+// reset, and when two-factor authentication is enabled, disabled or set up again.
+func (a *Account) CredentialsFingerprint(ctx context.Context) (string, error) {
+	h := sha256.New()
+	err := a.DB.Read(ctx, func(tx *bstore.Tx) error {
+		pw, err := bstore.QueryTx[Password](tx).Get()
+		if err != nil && err != bstore.ErrAbsent {
+			return fmt.Errorf("get password: %v", err)
+		}
+		h.Write([]byte(pw.Hash))
+		h.Write([]byte{0})
+		t := TOTP{ID: 1}
+		if err := tx.Get(&t); err != nil && err != bstore.ErrAbsent {
+			return fmt.Errorf("get two-factor authentication: %v", err)
+		} else if err == nil && t.Enabled {
+			h.Write(t.Secret)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// AccountLinkOpen opens the account of a link. If logins to the linked account are
+// disabled, an error matching ErrLoginDisabled is returned. If the credentials of
+// the linked account changed since linking, ErrAccountLinkInvalid is returned. The
+// caller must close the account.
+func AccountLinkOpen(ctx context.Context, log mlog.Log, link AccountLink) (*Account, error) {
+	if err := CheckLoginDisabled(link.Account); err != nil {
+		return nil, err
+	}
+	acc, err := OpenAccount(log, link.Account)
+	if err != nil {
+		return nil, err
+	}
+	fp, err := acc.CredentialsFingerprint(ctx)
+	if err == nil && fp != link.Credentials {
+		err = ErrAccountLinkInvalid
+	}
+	if err != nil {
+		xerr := acc.Close()
+		log.Check(xerr, "closing account")
+		return nil, err
+	}
+	return acc, nil
+}
+
+// accountLinksRemove removes the links to a removed account from all accounts, so
+// the links can't be used for a new account with the same name.
+func accountLinksRemove(log mlog.Log, accountName string) {
+	for _, name := range mox.Conf.Accounts() {
+		acc, err := OpenAccount(log, name)
+		if err != nil {
+			log.Errorx("open account for removing links to removed account", err, slog.String("account", name))
+			continue
+		}
+		n, err := bstore.QueryDB[AccountLink](context.TODO(), acc.DB).FilterNonzero(AccountLink{Account: accountName}).Delete()
+		log.Check(err, "removing links to removed account", slog.String("account", name), slog.String("removedaccount", accountName))
+		if n > 0 {
+			log.Info("removed link to removed account", slog.String("account", name), slog.String("removedaccount", accountName))
+		}
+		err = acc.Close()
+		log.Check(err, "closing account")
+	}
+}
+
+// AccountLinks returns the accounts linked to this account, sorted by name.
+func (a *Account) AccountLinks(ctx context.Context) ([]AccountLink, error) {
+	return bstore.QueryDB[AccountLink](ctx, a.DB).SortAsc("Account").List()
+}
+
+// AccountLinkGet returns the link to the named account. If the account is not
+// linked, bstore.ErrAbsent is returned.
+func (a *Account) AccountLinkGet(ctx context.Context, accountName string) (AccountLink, error) {
+	return bstore.QueryDB[AccountLink](ctx, a.DB).FilterNonzero(AccountLink{Account: accountName}).Get()
+}
+
+// AccountLinkAdd links the named account, or updates the login address and
+// credentials fingerprint of an existing link. The credentials for the account
+// must have been verified, credentials is its CredentialsFingerprint.
+func (a *Account) AccountLinkAdd(ctx context.Context, accountName, loginAddress, credentials string) (AccountLink, error) {
+	if accountName == a.Name {
+		return AccountLink{}, fmt.Errorf("cannot link account to itself")
+	}
+	var l AccountLink
+	err := a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		var err error
+		l, err = bstore.QueryTx[AccountLink](tx).FilterNonzero(AccountLink{Account: accountName}).Get()
+		if err == bstore.ErrAbsent {
+			l = AccountLink{Account: accountName, LoginAddress: loginAddress, Credentials: credentials}
+			return tx.Insert(&l)
+		} else if err != nil {
+			return fmt.Errorf("looking up existing link: %v", err)
+		}
+		l.LoginAddress = loginAddress
+		l.Credentials = credentials
+		return tx.Update(&l)
+	})
+	return l, err
+}
+
+// AccountLinkRemove removes a link to another account.
+func (a *Account) AccountLinkRemove(ctx context.Context, id int64) error {
+	return a.DB.Delete(ctx, &AccountLink{ID: id})
+}
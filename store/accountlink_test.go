@@ -0,0 +1,93 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+)
+
+func TestAccountLink(t *testing.T) {
+	log := mlog.New("store", nil)
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	acc, err := OpenAccount(log, "mjl")
+	tcheck(t, err, "open account")
+	defer func() {
+		err = acc.Close()
+		tcheck(t, err, "closing account")
+		acc.CheckClosed()
+	}()
+	defer Switchboard()()
+
+	_, err = acc.AccountLinkAdd(ctxbg, "mjl", "mjl@mox.example", "")
+	if err == nil {
+		t.Fatalf("linking account to itself succeeded")
+	}
+
+	l, err := acc.AccountLinkAdd(ctxbg, "other", "other@mox.example", "fp1")
+	tcheck(t, err, "add link")
+	// Adding again updates the login address.
+	l2, err := acc.AccountLinkAdd(ctxbg, "other", "other2@mox.example", "fp2")
+	tcheck(t, err, "add link again")
+	tcompare(t, l2.ID, l.ID)
+	tcompare(t, l2.Credentials, "fp2")
+
+	links, err := acc.AccountLinks(ctxbg)
+	tcheck(t, err, "list links")
+	tcompare(t, len(links), 1)
+	tcompare(t, links[0].LoginAddress, "other2@mox.example")
+
+	xl, err := acc.AccountLinkGet(ctxbg, "other")
+	tcheck(t, err, "get link")
+	tcompare(t, xl.ID, l.ID)
+
+	err = acc.AccountLinkRemove(ctxbg, l.ID)
+	tcheck(t, err, "remove link")
+	_, err = acc.AccountLinkGet(ctxbg, "other")
+	tcompare(t, err, bstore.ErrAbsent)
+
+	// Links are removed when the linked account is removed.
+	_, err = acc.AccountLinkAdd(ctxbg, "other", "other@mox.example", "fp1")
+	tcheck(t, err, "add link")
+	accountLinksRemove(log, "other")
+	_, err = acc.AccountLinkGet(ctxbg, "other")
+	tcompare(t, err, bstore.ErrAbsent)
+
+	// The fingerprint changes with the password, invalidating links.
+	err = acc.SetPassword(log, "test1234")
+	tcheck(t, err, "set password")
+	fp, err := acc.CredentialsFingerprint(ctxbg)
+	tcheck(t, err, "credentials fingerprint")
+	link := AccountLink{Account: "mjl", LoginAddress: "mjl@mox.example", Credentials: fp}
+	lacc, err := AccountLinkOpen(ctxbg, log, link)
+	tcheck(t, err, "open linked account")
+	err = lacc.Close()
+	tcheck(t, err, "close account")
+
+	err = acc.SetPassword(log, "test1234")
+	tcheck(t, err, "set password")
+	fp2, err := acc.CredentialsFingerprint(ctxbg)
+	tcheck(t, err, "credentials fingerprint")
+	if fp2 == fp {
+		t.Fatalf("fingerprint did not change with new password")
+	}
+	_, err = AccountLinkOpen(ctxbg, log, link)
+	tcompare(t, errors.Is(err, ErrAccountLinkInvalid), true)
+
+	// Logins to the linked account must not be disabled.
+	link.Credentials = fp2
+	accConf, _ := acc.Conf()
+	accConf.LoginDisabled = "testing"
+	mox.Conf.Dynamic.Accounts["mjl"] = accConf
+	_, err = AccountLinkOpen(ctxbg, log, link)
+	tcompare(t, errors.Is(err, ErrLoginDisabled), true)
+	accConf.LoginDisabled = ""
+	mox.Conf.Dynamic.Accounts["mjl"] = accConf
+}
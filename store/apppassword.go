@@ -0,0 +1,177 @@
+package store
+
+import (
+	"context"
+	cryptorand "crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mlog"
+)
+
+// AppPasswordProtocols are the protocols app passwords can be restricted to.
+var AppPasswordProtocols = []string{"imap", "smtp"}
+
+// ErrAppPasswordParam is returned when adding an app password with invalid
+// parameters.
+var ErrAppPasswordParam = errors.New("invalid app password parameter")
+
+// AppPassword is an additional password for an account, for use with IMAP and
+// SMTP submission only, not for logging in to the web interfaces. Each device
+// or email client can get its own app password, which can be removed
+// individually, e.g. when a device is lost. App passwords can only be used with
+// authentication mechanisms that send the password, i.e. IMAP LOGIN and SASL
+// PLAIN/LOGIN, not with SCRAM and CRAM-MD5.
+type AppPassword struct {
+	ID        int64
+	Label     string    `bstore:"nonzero"`          // Description, e.g. device or email client.
+	Hash      string    `bstore:"nonzero" json:"-"` // bcrypt hash.
+	Created   time.Time `bstore:"nonzero,default now"`
+	LastUsed  time.Time // Zero if never used.
+	Protocols []string  // Protocols the password can be used for, "imap" and/or "smtp". Empty means all.
+	IPNets    []string  // If non-empty, only allow authentication from these IP networks, in CIDR notation.
+}
+
+// allowed returns whether the app password can be used for protocol from ip.
+func (ap AppPassword) allowed(protocol string, ip net.IP) bool {
+	if len(ap.Protocols) > 0 && !slices.Contains(ap.Protocols, protocol) {
+		return false
+	}
+	if len(ap.IPNets) == 0 {
+		return true
+	}
+	for _, s := range ap.IPNets {
+		if _, ipnet, err := net.ParseCIDR(s); err == nil && ip != nil && ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// genAppPassword returns a new random password of 16 lower case letters, in
+// groups of 4 separated by dashes, for easy typing on a phone.
+func genAppPassword() string {
+	const chars = "abcdefghijklmnopqrstuvwxyz"
+	var s string
+	buf := make([]byte, 1)
+	for len(s) < 4*4+3 {
+		if len(s)%5 == 4 {
+			s += "-"
+		}
+		cryptorand.Read(buf)
+		i := int(buf[0])
+		if i >= 256/len(chars)*len(chars) {
+			continue // Prevent bias, only accept a multiple of len(chars) values.
+		}
+		s += string(chars[i%len(chars)])
+	}
+	return s
+}
+
+// AppPasswordAdd adds a new app password with a random password, which is
+// returned. The password cannot be retrieved later, only its hash is stored.
+// Protocols and ipNets restrict use of the app password, see AppPassword.
+func (a *Account) AppPasswordAdd(ctx context.Context, label string, protocols, ipNets []string) (AppPassword, string, error) {
+	label = strings.TrimSpace(label)
+	if label == "" {
+		return AppPassword{}, "", fmt.Errorf("%w: label required", ErrAppPasswordParam)
+	}
+	for _, p := range protocols {
+		if !slices.Contains(AppPasswordProtocols, p) {
+			return AppPassword{}, "", fmt.Errorf("%w: unknown protocol %q", ErrAppPasswordParam, p)
+		}
+	}
+	var nets []string
+	for _, s := range ipNets {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			// Single IP, turn into network.
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return AppPassword{}, "", fmt.Errorf("%w: invalid ip %q", ErrAppPasswordParam, s)
+			}
+			if ip.To4() != nil {
+				s += "/32"
+			} else {
+				s += "/128"
+			}
+		}
+		_, ipnet, err := net.ParseCIDR(s)
+		if err != nil {
+			return AppPassword{}, "", fmt.Errorf("%w: invalid ip network %q: %v", ErrAppPasswordParam, s, err)
+		}
+		nets = append(nets, ipnet.String())
+	}
+
+	password := genAppPassword()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return AppPassword{}, "", fmt.Errorf("generating password hash: %w", err)
+	}
+	ap := AppPassword{
+		Label:     label,
+		Hash:      string(hash),
+		Created:   time.Now(),
+		Protocols: protocols,
+		IPNets:    nets,
+	}
+	if err := a.DB.Insert(ctx, &ap); err != nil {
+		return AppPassword{}, "", fmt.Errorf("inserting app password: %v", err)
+	}
+	return ap, password, nil
+}
+
+// AppPasswords returns all app passwords of the account.
+func (a *Account) AppPasswords(ctx context.Context) ([]AppPassword, error) {
+	return bstore.QueryDB[AppPassword](ctx, a.DB).SortAsc("Created").List()
+}
+
+// AppPasswordRemove removes an app password. Existing IMAP/SMTP sessions that
+// authenticated with the app password are not closed, see ProtocolSessionClose.
+func (a *Account) AppPasswordRemove(ctx context.Context, id int64) error {
+	return a.DB.Delete(ctx, &AppPassword{ID: id})
+}
+
+// appPasswordAuth checks if password matches an app password that is allowed for
+// protocol and remoteIP. It returns ErrUnknownCredentials if not.
+func (a *Account) appPasswordAuth(log mlog.Log, email, password, protocol string, remoteIP net.IP) error {
+	l, err := bstore.QueryDB[AppPassword](context.TODO(), a.DB).List()
+	if err != nil {
+		return fmt.Errorf("listing app passwords: %v", err)
+	}
+	for _, ap := range l {
+		if !ap.allowed(protocol, remoteIP) {
+			continue
+		}
+		authCache.Lock()
+		ok := authCache.success[authKey{email, ap.Hash}] == password
+		authCache.Unlock()
+		if !ok {
+			if err := bcrypt.CompareHashAndPassword([]byte(ap.Hash), []byte(password)); err != nil {
+				continue
+			}
+			authCache.Lock()
+			authCache.success[authKey{email, ap.Hash}] = password
+			authCache.Unlock()
+		}
+
+		log.Debug("authenticated with app password", slog.Int64("apppasswordid", ap.ID), slog.String("label", ap.Label))
+		ap.LastUsed = time.Now()
+		err := a.DB.Update(context.TODO(), &ap)
+		log.Check(err, "updating last use of app password")
+		return nil
+	}
+	return ErrUnknownCredentials
+}
@@ -0,0 +1,85 @@
+package store
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+)
+
+func TestAppPasswords(t *testing.T) {
+	log := mlog.New("store", nil)
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	acc, err := OpenAccount(log, "mjl")
+	tcheck(t, err, "open account")
+	defer func() {
+		err = acc.Close()
+		tcheck(t, err, "closing account")
+		acc.CheckClosed()
+	}()
+
+	_, _, err = acc.AppPasswordAdd(ctxbg, "", nil, nil)
+	if !errors.Is(err, ErrAppPasswordParam) {
+		t.Fatalf("adding app password without label, got err %v, expected ErrAppPasswordParam", err)
+	}
+	_, _, err = acc.AppPasswordAdd(ctxbg, "test", []string{"pop3"}, nil)
+	if !errors.Is(err, ErrAppPasswordParam) {
+		t.Fatalf("adding app password with unknown protocol, got err %v, expected ErrAppPasswordParam", err)
+	}
+	_, _, err = acc.AppPasswordAdd(ctxbg, "test", nil, []string{"bogus"})
+	if !errors.Is(err, ErrAppPasswordParam) {
+		t.Fatalf("adding app password with bad ip network, got err %v, expected ErrAppPasswordParam", err)
+	}
+
+	ap, pw, err := acc.AppPasswordAdd(ctxbg, "phone", []string{"imap"}, []string{"10.0.0.0/8", "127.0.0.1"})
+	tcheck(t, err, "add app password")
+	if len(pw) != 19 || len(ap.IPNets) != 2 || ap.IPNets[1] != "127.0.0.1/32" {
+		t.Fatalf("unexpected app password %q %#v", pw, ap)
+	}
+
+	localIP := net.ParseIP("127.0.0.1")
+	otherIP := net.ParseIP("192.0.2.1")
+
+	xauth := func(password, protocol string, ip net.IP, expErr error) {
+		t.Helper()
+		a, err := OpenEmailAuthProtocol(log, "mjl@mox.example", password, protocol, ip)
+		if a != nil {
+			err := a.Close()
+			tcheck(t, err, "closing account")
+		}
+		if !errors.Is(err, expErr) {
+			t.Fatalf("auth with protocol %q and ip %s, got err %v, expected %v", protocol, ip, err, expErr)
+		}
+	}
+
+	xauth(pw, "imap", localIP, nil)
+	xauth(pw, "smtp", localIP, ErrUnknownCredentials) // Not allowed for protocol.
+	xauth(pw, "imap", otherIP, ErrUnknownCredentials) // Not allowed from IP.
+	xauth("wrong-password", "imap", localIP, ErrUnknownCredentials)
+
+	// Not for web logins.
+	a, err := OpenEmailAuth(log, "mjl@mox.example", pw)
+	if a != nil {
+		err := a.Close()
+		tcheck(t, err, "closing account")
+	}
+	if !errors.Is(err, ErrUnknownCredentials) {
+		t.Fatalf("web login with app password, got err %v, expected ErrUnknownCredentials", err)
+	}
+
+	l, err := acc.AppPasswords(ctxbg)
+	tcheck(t, err, "list app passwords")
+	if len(l) != 1 || l[0].LastUsed.IsZero() {
+		t.Fatalf("got app passwords %#v, expected one, recently used", l)
+	}
+
+	err = acc.AppPasswordRemove(ctxbg, ap.ID)
+	tcheck(t, err, "remove app password")
+	xauth(pw, "imap", localIP, ErrUnknownCredentials)
+}
@@ -0,0 +1,180 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/ical"
+)
+
+// ErrCalendarParam is returned when storing an invalid iCalendar object.
+var ErrCalendarParam = errors.New("invalid calendar parameter")
+
+// ErrCalendarPrecondition is returned by CalendarEventPut when an If-Match or
+// If-None-Match condition does not hold.
+var ErrCalendarPrecondition = errors.New("calendar precondition failed")
+
+// CalendarEvent is an event in the calendar of an account. Events are added when
+// accepting an invitation in webmail, and are synchronized with CalDAV clients.
+type CalendarEvent struct {
+	ID      int64
+	UID     string    `bstore:"nonzero,unique"` // UID of the VEVENT.
+	Href    string    `bstore:"nonzero,unique"` // Name of the resource in the CalDAV calendar, e.g. "<uid>.ics".
+	Created time.Time `bstore:"nonzero,default now"`
+	Updated time.Time `bstore:"nonzero,default now"` // Also used for CalDAV ETag.
+
+	Summary string
+	Start   time.Time `bstore:"index"`
+	End     time.Time
+	AllDay  bool
+
+	// Full iCalendar object, a VCALENDAR with one or more VEVENTs with the same UID
+	// (for overrides of recurring events), and VTIMEZONEs.
+	ICal string `json:"-"`
+}
+
+// ETag returns the value for the HTTP ETag header for the iCalendar object.
+func (e CalendarEvent) ETag() string {
+	return fmt.Sprintf(`"%x-%x"`, e.ID, e.Updated.UnixNano())
+}
+
+// CalendarData returns the iCalendar object of the event, for CalDAV.
+func (e CalendarEvent) CalendarData() []byte {
+	return []byte(e.ICal)
+}
+
+// calendarEventFromCal sets the fields of e from the iCalendar object.
+func calendarEventFromCal(e *CalendarEvent, cal ical.Component) error {
+	evs := cal.Events()
+	if len(evs) == 0 {
+		return fmt.Errorf("%w: no event in calendar object", ErrCalendarParam)
+	}
+	ev := evs[0]
+	uid := ev.Text("UID")
+	if uid == "" {
+		return fmt.Errorf("%w: event without uid", ErrCalendarParam)
+	}
+	for _, oev := range evs[1:] {
+		if oev.Text("UID") != uid {
+			return fmt.Errorf("%w: multiple events with different uid", ErrCalendarParam)
+		}
+	}
+	e.UID = uid
+	e.Summary = ev.Text("SUMMARY")
+	e.Start, e.End, e.AllDay = time.Time{}, time.Time{}, false
+	if p, ok := ev.Get("DTSTART"); ok {
+		t, allDay, err := p.Time(cal)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrCalendarParam, err)
+		}
+		e.Start, e.AllDay = t, allDay
+	}
+	if p, ok := ev.Get("DTEND"); ok {
+		t, _, err := p.Time(cal)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrCalendarParam, err)
+		}
+		e.End = t
+	}
+	// Calendar objects stored in a calendar collection must not have a METHOD, RFC
+	// 4791 section 4.1.
+	cal.Remove("METHOD")
+	e.ICal = string(cal.Marshal())
+	return nil
+}
+
+// CalendarEvents returns all events of the account, sorted by start time.
+func (a *Account) CalendarEvents(ctx context.Context) ([]CalendarEvent, error) {
+	return bstore.QueryDB[CalendarEvent](ctx, a.DB).SortAsc("Start").List()
+}
+
+// CalendarEventSave stores the event in the iCalendar object cal, as for an
+// accepted invitation. An existing event with the same UID is replaced.
+func (a *Account) CalendarEventSave(ctx context.Context, cal ical.Component) (CalendarEvent, error) {
+	var e CalendarEvent
+	if err := calendarEventFromCal(&e, cal); err != nil {
+		return CalendarEvent{}, err
+	}
+	err := a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		now := time.Now()
+		ne, err := bstore.QueryTx[CalendarEvent](tx).FilterNonzero(CalendarEvent{UID: e.UID}).Get()
+		if err == bstore.ErrAbsent {
+			e.Href = calendarHref(e.UID)
+			e.Created = now
+			e.Updated = now
+			return tx.Insert(&e)
+		} else if err != nil {
+			return err
+		}
+		e.ID, e.Href, e.Created, e.Updated = ne.ID, ne.Href, ne.Created, now
+		return tx.Update(&e)
+	})
+	return e, err
+}
+
+// calendarHref returns the resource name for a new event with uid. UIDs can
+// contain characters that are not safe in a path, like "@" and "/", so only safe
+// UIDs are used as is.
+func calendarHref(uid string) string {
+	for _, c := range uid {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return newContactUID() + ".ics"
+		}
+	}
+	return uid + ".ics"
+}
+
+// CalendarEventRemove removes an event.
+func (a *Account) CalendarEventRemove(ctx context.Context, id int64) error {
+	return a.DB.Delete(ctx, &CalendarEvent{ID: id})
+}
+
+// CalendarEventRemoveUID removes the event with uid, if it exists.
+func (a *Account) CalendarEventRemoveUID(ctx context.Context, uid string) error {
+	_, err := bstore.QueryDB[CalendarEvent](ctx, a.DB).FilterNonzero(CalendarEvent{UID: uid}).Delete()
+	return err
+}
+
+// CalendarEventPut stores a single iCalendar object as event under href, as with
+// a CalDAV PUT. If an event with href exists, it is replaced, otherwise a new
+// event is added. If ifMatch is non-empty, it must match the ETag of the existing
+// event. If ifNoneMatch is "*", the event must not exist yet.
+func (a *Account) CalendarEventPut(ctx context.Context, href string, data []byte, ifMatch, ifNoneMatch string) (e CalendarEvent, created bool, rerr error) {
+	cal, err := ical.Parse(bytes.NewReader(data))
+	if err != nil {
+		return CalendarEvent{}, false, fmt.Errorf("%w: %v", ErrCalendarParam, err)
+	}
+
+	err = a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		e, err = bstore.QueryTx[CalendarEvent](tx).FilterNonzero(CalendarEvent{Href: href}).Get()
+		if err == bstore.ErrAbsent {
+			if ifMatch != "" {
+				return ErrCalendarPrecondition
+			}
+			created = true
+			e = CalendarEvent{Href: href, Created: time.Now()}
+		} else if err != nil {
+			return err
+		} else if ifNoneMatch == "*" || ifMatch != "" && ifMatch != e.ETag() {
+			return ErrCalendarPrecondition
+		}
+
+		if err := calendarEventFromCal(&e, cal); err != nil {
+			return err
+		}
+		e.Updated = time.Now()
+		if created {
+			return tx.Insert(&e)
+		}
+		return tx.Update(&e)
+	})
+	if err != nil && errors.Is(err, bstore.ErrUnique) {
+		err = fmt.Errorf("%w: event with same uid already exists", ErrCalendarParam)
+	}
+	return e, created, err
+}
@@ -0,0 +1,94 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/ical"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+)
+
+func TestCalendar(t *testing.T) {
+	log := mlog.New("store", nil)
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	acc, err := OpenAccount(log, "mjl")
+	tcheck(t, err, "open account")
+	defer func() {
+		err = acc.Close()
+		tcheck(t, err, "closing account")
+		acc.CheckClosed()
+	}()
+	defer Switchboard()()
+
+	const ics = "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//test//EN\r\nMETHOD:REQUEST\r\nBEGIN:VEVENT\r\nUID:event1@mox.example\r\nDTSTAMP:20240101T120000Z\r\nDTSTART:20240110T090000Z\r\nDTEND:20240110T100000Z\r\nSUMMARY:Planning\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+	cal, err := ical.Parse(strings.NewReader(ics))
+	tcheck(t, err, "parse calendar")
+
+	e, err := acc.CalendarEventSave(ctxbg, cal)
+	tcheck(t, err, "save event")
+	tcompare(t, e.UID, "event1@mox.example")
+	tcompare(t, e.Summary, "Planning")
+	tcompare(t, e.Start, time.Date(2024, 1, 10, 9, 0, 0, 0, time.UTC))
+	if !strings.HasSuffix(e.Href, ".ics") || strings.Contains(e.Href, "@") {
+		t.Fatalf("bad href %q", e.Href)
+	}
+	if strings.Contains(e.ICal, "METHOD:") {
+		t.Fatalf("method not removed from stored event")
+	}
+
+	// Saving again, e.g. for an updated invitation, replaces the event.
+	cal.Events()[0].Props[len(cal.Events()[0].Props)-1] = ical.Prop{Name: "SUMMARY", Value: "Planning 2"}
+	e2, err := acc.CalendarEventSave(ctxbg, cal)
+	tcheck(t, err, "save event")
+	tcompare(t, e2.ID, e.ID)
+	tcompare(t, e2.Href, e.Href)
+	tcompare(t, e2.Summary, "Planning 2")
+
+	// Put through CalDAV with precondition checks.
+	_, _, err = acc.CalendarEventPut(ctxbg, e.Href, []byte(ics), "", "*")
+	if !errors.Is(err, ErrCalendarPrecondition) {
+		t.Fatalf("put with if-none-match, got err %v, expected ErrCalendarPrecondition", err)
+	}
+	_, _, err = acc.CalendarEventPut(ctxbg, e.Href, []byte(ics), `"bogus"`, "")
+	if !errors.Is(err, ErrCalendarPrecondition) {
+		t.Fatalf("put with bad if-match, got err %v, expected ErrCalendarPrecondition", err)
+	}
+	e3, created, err := acc.CalendarEventPut(ctxbg, e.Href, []byte(ics), e2.ETag(), "")
+	tcheck(t, err, "put event")
+	tcompare(t, created, false)
+	tcompare(t, e3.Summary, "Planning")
+
+	_, _, err = acc.CalendarEventPut(ctxbg, "other.ics", []byte(ics), "", "")
+	if !errors.Is(err, ErrCalendarParam) {
+		t.Fatalf("put with duplicate uid, got err %v, expected ErrCalendarParam", err)
+	}
+	_, _, err = acc.CalendarEventPut(ctxbg, "other.ics", []byte("bogus"), "", "")
+	if !errors.Is(err, ErrCalendarParam) {
+		t.Fatalf("put with bad data, got err %v, expected ErrCalendarParam", err)
+	}
+	allday := strings.ReplaceAll(strings.ReplaceAll(ics, "event1", "event2"), "DTSTART:20240110T090000Z", "DTSTART;VALUE=DATE:20240109")
+	e4, created, err := acc.CalendarEventPut(ctxbg, "other.ics", []byte(allday), "", "*")
+	tcheck(t, err, "put new event")
+	tcompare(t, created, true)
+	tcompare(t, e4.AllDay, true)
+
+	l, err := acc.CalendarEvents(ctxbg)
+	tcheck(t, err, "list events")
+	tcompare(t, len(l), 2)
+	tcompare(t, l[0].ID, e4.ID)
+
+	err = acc.CalendarEventRemoveUID(ctxbg, "event1@mox.example")
+	tcheck(t, err, "remove event by uid")
+	err = acc.CalendarEventRemove(ctxbg, e4.ID)
+	tcheck(t, err, "remove event")
+	l, err = acc.CalendarEvents(ctxbg)
+	tcheck(t, err, "list events")
+	tcompare(t, len(l), 0)
+}
@@ -0,0 +1,356 @@
+package store
+
+// Compression of message files.
+//
+// Whether a message file is compressed is recorded in the database, in
+// Message.FileCompressed, like encryption.
+//
+// Compressed message files start with a magic value, followed by chunks of up to
+// 128KB of message data, each compressed separately as a zstd frame. The file ends
+// with an index of the compressed size of each chunk, the size of the message and
+// the number of chunks. Chunks allow random access, e.g. for IMAP partial fetches,
+// without decompressing the whole file. Compressed files can also be encrypted,
+// compression is applied first.
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mlog"
+)
+
+// Magic value at start of compressed message files. Only used to detect
+// inconsistencies with the database.
+const cmpMagic = "\x00moxcmp1"
+
+const (
+	cmpChunkSize   = 128 * 1024
+	cmpTrailerSize = 8 + 4 // Message size and number of chunks.
+)
+
+// Encoder and decoder for chunks, created on first use. EncodeAll and DecodeAll
+// can be used concurrently.
+var (
+	cmpEncoder = sync.OnceValue(func() *zstd.Encoder {
+		enc, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithWindowSize(cmpChunkSize))
+		if err != nil {
+			panic(fmt.Sprintf("zstd encoder: %v", err))
+		}
+		return enc
+	})
+	cmpDecoder = sync.OnceValue(func() *zstd.Decoder {
+		dec, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(0), zstd.WithDecoderMaxMemory(cmpChunkSize))
+		if err != nil {
+			panic(fmt.Sprintf("zstd decoder: %v", err))
+		}
+		return dec
+	})
+)
+
+// writeCompressed writes the contents of r to w in compressed form.
+func writeCompressed(w io.Writer, r io.Reader) error {
+	if _, err := w.Write([]byte(cmpMagic)); err != nil {
+		return fmt.Errorf("writing header: %v", err)
+	}
+
+	var sizes []uint32
+	var total int64
+	buf := make([]byte, cmpChunkSize)
+	var cbuf []byte
+	enc := cmpEncoder()
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			cbuf = enc.EncodeAll(buf[:n], cbuf[:0])
+			if _, err := w.Write(cbuf); err != nil {
+				return fmt.Errorf("writing chunk: %v", err)
+			}
+			sizes = append(sizes, uint32(len(cbuf)))
+			total += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("reading message: %v", err)
+		}
+	}
+
+	index := make([]byte, 4*len(sizes)+cmpTrailerSize)
+	for i, size := range sizes {
+		binary.BigEndian.PutUint32(index[4*i:], size)
+	}
+	binary.BigEndian.PutUint64(index[4*len(sizes):], uint64(total))
+	binary.BigEndian.PutUint32(index[4*len(sizes)+8:], uint32(len(sizes)))
+	if _, err := w.Write(index); err != nil {
+		return fmt.Errorf("writing index: %v", err)
+	}
+	return nil
+}
+
+// openCompressed reads the index of a compressed message file, and returns a
+// file for reading the decompressed message, and its size.
+func openCompressed(mf messageFile, size int64) (messageFile, int64, error) {
+	if size < int64(len(cmpMagic)+cmpTrailerSize) {
+		return nil, 0, fmt.Errorf("message file too small for compressed file, inconsistent with database")
+	}
+	buf := make([]byte, len(cmpMagic))
+	if _, err := mf.ReadAt(buf, 0); err != nil {
+		return nil, 0, fmt.Errorf("reading header: %v", err)
+	} else if string(buf) != cmpMagic {
+		return nil, 0, fmt.Errorf("message file is not compressed, inconsistent with database")
+	}
+	trailer := make([]byte, cmpTrailerSize)
+	if _, err := mf.ReadAt(trailer, size-cmpTrailerSize); err != nil {
+		return nil, 0, fmt.Errorf("reading compressed file trailer: %v", err)
+	}
+	msgSize := int64(binary.BigEndian.Uint64(trailer))
+	nchunks := int64(binary.BigEndian.Uint32(trailer[8:]))
+	indexOffset := size - cmpTrailerSize - 4*nchunks
+	if indexOffset < int64(len(cmpMagic)) || msgSize > nchunks*cmpChunkSize || msgSize <= (nchunks-1)*cmpChunkSize {
+		return nil, 0, fmt.Errorf("bad compressed file trailer")
+	}
+	index := make([]byte, 4*nchunks)
+	if _, err := mf.ReadAt(index, indexOffset); err != nil {
+		return nil, 0, fmt.Errorf("reading compressed file index: %v", err)
+	}
+	offsets := make([]int64, nchunks+1)
+	offsets[0] = int64(len(cmpMagic))
+	for i := int64(0); i < nchunks; i++ {
+		offsets[i+1] = offsets[i] + int64(binary.BigEndian.Uint32(index[4*i:]))
+	}
+	if offsets[nchunks] != indexOffset {
+		return nil, 0, fmt.Errorf("bad compressed file index")
+	}
+	return &decompressFile{mf: mf, offsets: offsets, size: msgSize, chunk: -1}, msgSize, nil
+}
+
+// decompressFile provides random access to the message in a compressed message
+// file. The most recently decompressed chunk is kept.
+type decompressFile struct {
+	mf      messageFile
+	offsets []int64 // Start of compressed chunks, and end of last chunk.
+	size    int64   // Of message.
+	chunk   int64   // Index of chunk in buf, -1 if none.
+	buf     []byte  // Decompressed chunk.
+	cbuf    []byte  // For reading compressed chunk.
+}
+
+func (d *decompressFile) load(index int64) error {
+	if d.chunk == index {
+		return nil
+	}
+	d.chunk = -1
+	n := min(cmpChunkSize, d.size-index*cmpChunkSize)
+	if d.buf == nil {
+		d.buf = make([]byte, 0, cmpChunkSize)
+	}
+	if d.cbuf == nil {
+		d.cbuf = make([]byte, cmpChunkSize)
+	}
+	csize := d.offsets[index+1] - d.offsets[index]
+	if int64(cap(d.cbuf)) < csize {
+		d.cbuf = make([]byte, csize)
+	}
+	cbuf := d.cbuf[:csize]
+	if _, err := d.mf.ReadAt(cbuf, d.offsets[index]); err != nil {
+		return fmt.Errorf("reading message file chunk %d: %v", index, err)
+	}
+	buf, err := cmpDecoder().DecodeAll(cbuf, d.buf[:0])
+	if err != nil {
+		return fmt.Errorf("decompressing message file chunk %d: %v", index, err)
+	} else if int64(len(buf)) != n {
+		return fmt.Errorf("decompressed message file chunk %d has size %d, expected %d", index, len(buf), n)
+	}
+	d.buf = buf
+	d.chunk = index
+	return nil
+}
+
+func (d *decompressFile) ReadAt(buf []byte, off int64) (int, error) {
+	var o int
+	for o < len(buf) {
+		if off >= d.size {
+			return o, io.EOF
+		}
+		index := off / cmpChunkSize
+		if err := d.load(index); err != nil {
+			return o, err
+		}
+		n := copy(buf[o:], d.buf[off-index*cmpChunkSize:])
+		o += n
+		off += int64(n)
+	}
+	return o, nil
+}
+
+func (d *decompressFile) Close() error {
+	return d.mf.Close()
+}
+
+// fileFormat is the format of a message file, see Message.FileEncrypted and
+// Message.FileCompressed.
+type fileFormat struct {
+	encrypted  bool
+	compressed bool
+}
+
+// CompressStats holds statistics about the storage of the message files of an
+// account.
+type CompressStats struct {
+	Messages   int   // Number of message files.
+	Compressed int   // Number of message files compressed by CompressMessageFiles.
+	Size       int64 // Total size of the messages in the files.
+	DiskBefore int64 // Total size of the files on disk before compressing.
+	DiskAfter  int64 // Total size of the files on disk after compressing.
+}
+
+// CompressMessageFiles compresses all message files of the account that are not
+// yet compressed, e.g. those delivered before compression was enabled. If
+// encryption at rest is configured, the compressed files are encrypted too.
+// Messages are processed in batches, each with the account write lock held.
+func (a *Account) CompressMessageFiles(ctx context.Context, log mlog.Log) (CompressStats, error) {
+	var stats CompressStats
+	key := messageKey(a.Name)
+	err := a.forEachMessageFile(ctx, func(m Message, p string) error {
+		return a.compressMessageFile(ctx, log, m, p, key, &stats)
+	})
+	return stats, err
+}
+
+// forEachMessageFile calls fn for each message that is not expunged, with the
+// path to its message file. Messages are processed in batches, each with the
+// account write lock held, so files can be replaced without racing with removal
+// of messages.
+func (a *Account) forEachMessageFile(ctx context.Context, fn func(m Message, path string) error) error {
+	const batchSize = 100
+
+	var lastID int64
+	for {
+		var n int
+		var err error
+		a.WithWLock(func() {
+			var msgs []Message
+			q := bstore.QueryDB[Message](ctx, a.DB)
+			q.FilterGreater("ID", lastID)
+			q.FilterEqual("Expunged", false)
+			q.SortAsc("ID")
+			q.Limit(batchSize)
+			msgs, err = q.List()
+			if err != nil {
+				err = fmt.Errorf("listing messages: %v", err)
+				return
+			}
+			n = len(msgs)
+			for _, m := range msgs {
+				lastID = m.ID
+				if err = fn(m, a.MessagePath(m.ID)); err != nil {
+					return
+				}
+			}
+		})
+		if err != nil || n < batchSize {
+			return err
+		}
+	}
+}
+
+func (a *Account) compressMessageFile(ctx context.Context, log mlog.Log, m Message, p string, key []byte, stats *CompressStats) error {
+	st, err := os.Stat(p)
+	if err != nil {
+		return fmt.Errorf("stat message file for message id %d: %v", m.ID, err)
+	}
+	mf, size, err := openMessageFile(p, key, m.FileEncrypted, m.FileCompressed)
+	if err != nil {
+		return fmt.Errorf("opening message file for message id %d: %v", m.ID, err)
+	}
+	defer func() {
+		err := mf.Close()
+		log.Check(err, "closing message file")
+	}()
+
+	stats.Messages++
+	stats.Size += size
+	stats.DiskBefore += st.Size()
+	if m.FileCompressed {
+		stats.DiskAfter += st.Size()
+		return nil
+	}
+
+	// Write to temporary file next to message file, and replace it atomically.
+	// Readers that have the original file open can continue reading.
+	tmpPath := p + ".compress"
+	origPath := p + ".orig"
+	for _, xp := range []string{tmpPath, origPath} {
+		err = os.Remove(xp)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Errorx("removing leftover temporary file", err, slog.String("path", xp))
+		}
+	}
+	if err := writeMessageFile(tmpPath, io.NewSectionReader(mf, 0, size), key, true, true); err != nil {
+		return fmt.Errorf("writing compressed message file for message id %d: %v", m.ID, err)
+	}
+	nst, err := os.Stat(tmpPath)
+	if err == nil {
+		// Keep the original file until the database is updated, to restore it if the
+		// update fails.
+		err = os.Link(p, origPath)
+	}
+	if err != nil {
+		xerr := os.Remove(tmpPath)
+		log.Check(xerr, "removing temporary compressed message file")
+		return fmt.Errorf("preparing replacement of message file for message id %d: %v", m.ID, err)
+	}
+	removeOrig := func() {
+		err := os.Remove(origPath)
+		log.Check(err, "removing link to original message file", slog.String("path", origPath))
+	}
+
+	// Readers may have read the message from the database before we update it, with
+	// the old format. They look up the new format while opening the file.
+	ff := fileFormat{key != nil, true}
+	a.fileFormats.Lock()
+	err = os.Rename(tmpPath, p)
+	if err == nil {
+		if a.fileFormats.m == nil {
+			a.fileFormats.m = map[int64]fileFormat{}
+		}
+		a.fileFormats.m[m.ID] = ff
+	}
+	a.fileFormats.Unlock()
+	if err != nil {
+		xerr := os.Remove(tmpPath)
+		log.Check(xerr, "removing temporary compressed message file")
+		removeOrig()
+		return fmt.Errorf("replacing message file for message id %d: %v", m.ID, err)
+	}
+
+	m.FileEncrypted = ff.encrypted
+	m.FileCompressed = ff.compressed
+	if err := a.DB.Update(ctx, &m); err != nil {
+		a.fileFormats.Lock()
+		xerr := os.Rename(origPath, p)
+		if xerr == nil {
+			delete(a.fileFormats.m, m.ID)
+		}
+		a.fileFormats.Unlock()
+		if xerr != nil {
+			log.Errorx("restoring original message file after failed database update, message file is compressed but database says it is not", xerr, slog.String("path", p))
+		}
+		return fmt.Errorf("updating message id %d for new message file: %v", m.ID, err)
+	}
+	removeOrig()
+	stats.Compressed++
+	stats.DiskAfter += nst.Size()
+	return nil
+}
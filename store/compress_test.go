@@ -0,0 +1,155 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/mox-"
+)
+
+func TestMessageCompression(t *testing.T) {
+	log := pkglog
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	defer func() {
+		mox.Conf.Static.CompressMessages = false
+		mox.Conf.Static.MessageEncryption = nil
+	}()
+	acc, err := OpenAccount(log, "mjl")
+	tcheck(t, err, "open account")
+	defer func() {
+		err = acc.Close()
+		tcheck(t, err, "closing account")
+		acc.CheckClosed()
+	}()
+	defer Switchboard()()
+
+	// Message spanning multiple chunks.
+	msg := "Subject: compressed\r\n\r\n" + strings.Repeat("text that compresses well\r\n", 20000)
+	deliver := func() Message {
+		t.Helper()
+		msgFile, err := CreateMessageTemp(log, "compress-test")
+		tcheck(t, err, "create temp message")
+		defer os.Remove(msgFile.Name())
+		defer msgFile.Close()
+		_, err = msgFile.Write([]byte(msg))
+		tcheck(t, err, "write message")
+		m := Message{Received: time.Now(), Size: int64(len(msg))}
+		acc.WithWLock(func() {
+			err = acc.DeliverMailbox(log, "Inbox", &m, msgFile)
+		})
+		tcheck(t, err, "deliver message")
+		return m
+	}
+	diskSize := func(m Message) int64 {
+		t.Helper()
+		st, err := os.Stat(acc.MessagePath(m.ID))
+		tcheck(t, err, "stat message file")
+		return st.Size()
+	}
+	check := func(m Message) {
+		t.Helper()
+		msgr := acc.MessageReader(m)
+		defer msgr.Close()
+		data, err := io.ReadAll(msgr)
+		tcheck(t, err, "read message")
+		tcompare(t, string(data), msg)
+		buf := make([]byte, 100)
+		off := int64(cmpChunkSize - 50)
+		n, err := msgr.ReadAt(buf, off)
+		tcheck(t, err, "readat")
+		tcompare(t, string(buf[:n]), msg[off:off+100])
+	}
+
+	plain := deliver()
+	tcompare(t, diskSize(plain), int64(len(msg)))
+
+	mox.Conf.Static.CompressMessages = true
+	m := deliver()
+	if diskSize(m) > int64(len(msg))/10 {
+		t.Fatalf("compressed message file too large, %d for message of %d bytes", diskSize(m), len(msg))
+	}
+	tcompare(t, m.FileCompressed, true)
+	check(m)
+
+	// Compressed and encrypted.
+	mox.Conf.Static.MessageEncryption = &config.MessageEncryption{Key: bytes.Repeat([]byte{1}, 32)}
+	// Like opening the account after configuring encryption at rest.
+	err = UpgradeSealed(ctxbg, log, acc.Name, acc.DB)
+	tcheck(t, err, "upgrade messages for encryption at rest")
+	em := deliver()
+	buf, err := os.ReadFile(acc.MessagePath(em.ID))
+	tcheck(t, err, "read message file")
+	tcompare(t, strings.HasPrefix(string(buf), encMagic), true)
+	if len(buf) > len(msg)/10 {
+		t.Fatalf("compressed encrypted message file too large")
+	}
+	check(em)
+	size, err := MessageFileSize(acc.MessagePath(em.ID), messageKey(acc.Name), em.FileEncrypted, em.FileCompressed)
+	tcheck(t, err, "message file size")
+	tcompare(t, size, int64(len(msg)))
+
+	err = acc.CheckConsistency()
+	tcheck(t, err, "check consistency")
+
+	// If the database update fails, the original file is restored.
+	canceledCtx, cancel := context.WithCancel(ctxbg)
+	cancel()
+	var rstats CompressStats
+	acc.WithWLock(func() {
+		err = acc.compressMessageFile(canceledCtx, log, plain, acc.MessagePath(plain.ID), messageKey(acc.Name), &rstats)
+	})
+	if err == nil {
+		t.Fatalf("compressing with failing database update succeeded")
+	}
+	tcompare(t, diskSize(plain), int64(len(msg)))
+	_, err = os.Stat(acc.MessagePath(plain.ID) + ".orig")
+	tcompare(t, errors.Is(err, fs.ErrNotExist), true)
+	check(plain)
+
+	// Compress existing plain file, which also gets encrypted. The message read
+	// before compressing still reads correctly.
+	stale := plain
+	stats, err := acc.CompressMessageFiles(ctxbg, log)
+	tcheck(t, err, "compress message files")
+	tcompare(t, stats.Messages, 3)
+	tcompare(t, stats.Compressed, 1)
+	tcompare(t, stats.Size, 3*int64(len(msg)))
+	if stats.DiskAfter >= stats.DiskBefore {
+		t.Fatalf("no space saved, before %d, after %d", stats.DiskBefore, stats.DiskAfter)
+	}
+	err = acc.DB.Get(ctxbg, &plain)
+	tcheck(t, err, "get message")
+	tcompare(t, plain.FileEncrypted, true)
+	check(plain)
+	check(stale)
+	buf, err = os.ReadFile(acc.MessagePath(plain.ID))
+	tcheck(t, err, "read message file")
+	tcompare(t, strings.HasPrefix(string(buf), encMagic), true)
+
+	stats, err = acc.CompressMessageFiles(ctxbg, log)
+	tcheck(t, err, "compress message files")
+	tcompare(t, stats.Compressed, 0)
+	tcompare(t, stats.DiskAfter, stats.DiskBefore)
+
+	// Plain message that looks like a compressed file is read as is.
+	mox.Conf.Static.CompressMessages = false
+	msg = cmpMagic + strings.Repeat("\x00", 100)
+	pm := deliver()
+	tcompare(t, pm.FileCompressed, false)
+	msgr := acc.MessageReader(pm)
+	data, err := io.ReadAll(msgr)
+	tcheck(t, err, "read plain message")
+	tcompare(t, string(data), msg)
+	msgr.Close()
+}
@@ -0,0 +1,369 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	cryptorand "crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/smtp"
+	"github.com/mjl-/mox/vcard"
+)
+
+// ErrContactParam is returned when saving a contact with invalid parameters, or
+// when importing an invalid vCard.
+var ErrContactParam = errors.New("invalid contact parameter")
+
+// ErrContactPrecondition is returned by ContactPut when an If-Match or
+// If-None-Match condition does not hold.
+var ErrContactPrecondition = errors.New("contact precondition failed")
+
+// Contact is an entry in the address book of an account. Contacts are managed in
+// the account web interface, synchronized with CardDAV, and used for completing
+// recipient addresses in webmail. Recipients of messages in the Sent mailbox are
+// added automatically, as harvested contacts.
+type Contact struct {
+	ID      int64
+	UID     string    `bstore:"nonzero,unique"` // Unique ID, also in the vCard.
+	Href    string    `bstore:"nonzero,unique"` // Name of the vCard resource in the CardDAV address book, e.g. "<uid>.vcf".
+	Created time.Time `bstore:"nonzero,default now"`
+	Updated time.Time `bstore:"nonzero,default now"` // Also used for CardDAV ETag.
+
+	Name         string   // Full name, vCard FN property.
+	Emails       []string `bstore:"index"` // Email addresses, normalized.
+	Phones       []string
+	Organization string
+	Notes        string
+
+	// Added automatically as recipient of a sent message. Cleared when the contact
+	// is edited.
+	Harvested bool
+
+	// Full vCard, including properties not represented by the fields above.
+	VCard string `json:"-"`
+}
+
+// ETag returns the value for the HTTP ETag header for the vCard of the contact.
+func (c Contact) ETag() string {
+	return fmt.Sprintf(`"%x-%x"`, c.ID, c.Updated.UnixNano())
+}
+
+// normalizeContactEmail returns the address in its normalized form, as used for
+// the Emails field.
+func normalizeContactEmail(s string) (string, error) {
+	addr, err := smtp.ParseAddress(strings.TrimSpace(s))
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid email address %q: %v", ErrContactParam, s, err)
+	}
+	return addr.String(), nil
+}
+
+func newContactUID() string {
+	buf := make([]byte, 12)
+	if _, err := cryptorand.Read(buf); err != nil {
+		panic(fmt.Sprintf("reading random bytes: %v", err))
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// card returns the vCard for the contact, based on the stored vCard with the
+// fields of the contact applied.
+func (c Contact) card() vcard.Card {
+	var card vcard.Card
+	if c.VCard != "" {
+		if cards, err := vcard.Parse(strings.NewReader(c.VCard)); err == nil && len(cards) == 1 {
+			card = cards[0]
+		}
+	}
+	if _, ok := card.Get("VERSION"); !ok {
+		card.Props = append([]vcard.Prop{{Name: "VERSION", Value: "3.0"}}, card.Props...)
+	}
+	card.SetTexts("UID", c.UID)
+	card.SetTexts("FN", c.Name)
+	if _, ok := card.Get("N"); !ok || card.Text("FN") != c.Name {
+		// N is required in vCard 3.0. We don't know the structure of names, so we put
+		// the last word in the family name, and the rest as given name.
+		var family, given string
+		if i := strings.LastIndexByte(c.Name, ' '); i >= 0 {
+			given, family = c.Name[:i], c.Name[i+1:]
+		} else {
+			family = c.Name
+		}
+		var props []vcard.Prop
+		for _, p := range card.Props {
+			if p.Name != "N" {
+				props = append(props, p)
+			}
+		}
+		card.Props = append(props, vcard.Prop{Name: "N", Value: vcard.Escape(family) + ";" + vcard.Escape(given) + ";;;"})
+	}
+	card.SetTexts("EMAIL", c.Emails...)
+	card.SetTexts("TEL", c.Phones...)
+	if c.Organization != cardOrganization(card) {
+		card.SetTexts("ORG", c.Organization)
+	}
+	card.SetTexts("NOTE", c.Notes)
+	return card
+}
+
+// cardOrganization returns the organization name from the ORG property. ORG is
+// structured, with organization name and units separated by semicolons.
+func cardOrganization(card vcard.Card) string {
+	org, _ := card.Get("ORG")
+	name, _, _ := strings.Cut(org.Value, ";")
+	return vcard.Prop{Value: name}.Text()
+}
+
+// contactFromCard sets the fields of c from card.
+func contactFromCard(c *Contact, card vcard.Card) error {
+	if uid := card.Text("UID"); uid != "" {
+		c.UID = strings.TrimPrefix(uid, "urn:uuid:")
+	}
+	c.Name = card.Text("FN")
+	c.Emails = nil
+	for _, s := range card.Texts("EMAIL") {
+		if s == "" {
+			continue
+		}
+		e, err := normalizeContactEmail(s)
+		if err != nil {
+			return err
+		}
+		c.Emails = append(c.Emails, e)
+	}
+	c.Phones = card.Texts("TEL")
+	c.Organization = cardOrganization(card)
+	c.Notes = card.Text("NOTE")
+	c.VCard = string(card.Marshal())
+	return nil
+}
+
+// Contacts returns all contacts of the account, sorted by name.
+func (a *Account) Contacts(ctx context.Context) ([]Contact, error) {
+	l, err := bstore.QueryDB[Contact](ctx, a.DB).List()
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(l, func(i, j int) bool {
+		return strings.ToLower(l[i].Name) < strings.ToLower(l[j].Name)
+	})
+	return l, nil
+}
+
+// ContactSave adds a new contact if c.ID is zero, or updates an existing contact.
+// Properties in the vCard of an existing contact that are not represented by
+// fields of Contact are kept. The saved contact is returned.
+func (a *Account) ContactSave(ctx context.Context, c Contact) (Contact, error) {
+	c.Name = strings.TrimSpace(c.Name)
+	var emails []string
+	for _, s := range c.Emails {
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+		e, err := normalizeContactEmail(s)
+		if err != nil {
+			return Contact{}, err
+		}
+		emails = append(emails, e)
+	}
+	if c.Name == "" && len(emails) == 0 {
+		return Contact{}, fmt.Errorf("%w: name or email address required", ErrContactParam)
+	}
+	var phones []string
+	for _, s := range c.Phones {
+		if s = strings.TrimSpace(s); s != "" {
+			phones = append(phones, s)
+		}
+	}
+
+	var nc Contact
+	err := a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		now := time.Now()
+		if c.ID == 0 {
+			uid := newContactUID()
+			nc = Contact{UID: uid, Href: uid + ".vcf", Created: now}
+		} else {
+			nc = Contact{ID: c.ID}
+			if err := tx.Get(&nc); err != nil {
+				return err
+			}
+		}
+		nc.Updated = now
+		nc.Name = c.Name
+		nc.Emails = emails
+		nc.Phones = phones
+		nc.Organization = strings.TrimSpace(c.Organization)
+		nc.Notes = c.Notes
+		nc.Harvested = false
+		nc.VCard = string(nc.card().Marshal())
+		if nc.ID == 0 {
+			return tx.Insert(&nc)
+		}
+		return tx.Update(&nc)
+	})
+	return nc, err
+}
+
+// ContactRemove removes a contact.
+func (a *Account) ContactRemove(ctx context.Context, id int64) error {
+	return a.DB.Delete(ctx, &Contact{ID: id})
+}
+
+// ContactPut stores a single vCard as contact under href, as with a CardDAV PUT.
+// If a contact with href exists, it is replaced, otherwise a new contact is
+// added. If ifMatch is non-empty, it must match the ETag of the existing contact.
+// If ifNoneMatch is "*", the contact must not exist yet.
+func (a *Account) ContactPut(ctx context.Context, href string, data []byte, ifMatch, ifNoneMatch string) (c Contact, created bool, rerr error) {
+	cards, err := vcard.Parse(bytes.NewReader(data))
+	if err != nil {
+		return Contact{}, false, fmt.Errorf("%w: %v", ErrContactParam, err)
+	} else if len(cards) != 1 {
+		return Contact{}, false, fmt.Errorf("%w: need exactly one vcard, got %d", ErrContactParam, len(cards))
+	}
+
+	err = a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		c, err = bstore.QueryTx[Contact](tx).FilterNonzero(Contact{Href: href}).Get()
+		if err == bstore.ErrAbsent {
+			if ifMatch != "" {
+				return ErrContactPrecondition
+			}
+			created = true
+			c = Contact{Href: href, Created: time.Now()}
+		} else if err != nil {
+			return err
+		} else if ifNoneMatch == "*" || ifMatch != "" && ifMatch != c.ETag() {
+			return ErrContactPrecondition
+		}
+
+		if err := contactFromCard(&c, cards[0]); err != nil {
+			return err
+		}
+		if c.UID == "" {
+			c.UID = newContactUID()
+			c.VCard = string(c.card().Marshal())
+		}
+		c.Harvested = false
+		c.Updated = time.Now()
+		if created {
+			return tx.Insert(&c)
+		}
+		return tx.Update(&c)
+	})
+	if err != nil && errors.Is(err, bstore.ErrUnique) {
+		err = fmt.Errorf("%w: contact with same uid already exists", ErrContactParam)
+	}
+	return c, created, err
+}
+
+// ContactsImport adds contacts from one or more vCards. Existing contacts with the
+// same UID are updated. The number of added/updated contacts is returned.
+func (a *Account) ContactsImport(ctx context.Context, r io.Reader) (int, error) {
+	cards, err := vcard.Parse(r)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrContactParam, err)
+	}
+	var n int
+	err = a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		for _, card := range cards {
+			var c Contact
+			if uid := strings.TrimPrefix(card.Text("UID"), "urn:uuid:"); uid != "" {
+				var err error
+				c, err = bstore.QueryTx[Contact](tx).FilterNonzero(Contact{UID: uid}).Get()
+				if err != nil && err != bstore.ErrAbsent {
+					return err
+				}
+			}
+			if err := contactFromCard(&c, card); err != nil {
+				return err
+			}
+			if c.UID == "" {
+				c.UID = newContactUID()
+				c.VCard = string(c.card().Marshal())
+			}
+			c.Harvested = false
+			c.Updated = time.Now()
+			if c.ID == 0 {
+				c.Href = c.UID + ".vcf"
+				c.Created = c.Updated
+				if err := tx.Insert(&c); err != nil {
+					return err
+				}
+			} else if err := tx.Update(&c); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// ContactsExport writes all contacts as vCards to w.
+func (a *Account) ContactsExport(ctx context.Context, w io.Writer) error {
+	l, err := a.Contacts(ctx)
+	if err != nil {
+		return err
+	}
+	for _, c := range l {
+		if _, err := w.Write(c.card().Marshal()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CardData returns the vCard of the contact, for CardDAV.
+func (c Contact) CardData() []byte {
+	return c.card().Marshal()
+}
+
+// contactHarvest adds a contact for a recipient of a sent message, if no contact
+// with the address exists yet.
+func contactHarvest(log mlog.Log, tx *bstore.Tx, name string, lp smtp.Localpart, d dns.Domain) error {
+	email := smtp.NewAddress(lp, d).String()
+	exists, err := bstore.QueryTx[Contact](tx).FilterIn("Emails", email).Exists()
+	if err != nil {
+		return fmt.Errorf("looking up contact: %w", err)
+	} else if exists {
+		return nil
+	}
+	uid := newContactUID()
+	c := Contact{
+		UID:       uid,
+		Href:      uid + ".vcf",
+		Name:      name,
+		Emails:    []string{email},
+		Harvested: true,
+	}
+	c.VCard = string(c.card().Marshal())
+	if err := tx.Insert(&c); err != nil {
+		return fmt.Errorf("inserting harvested contact: %w", err)
+	}
+	log.Debug("added harvested contact", slog.String("email", email))
+	return nil
+}
+
+// KnownSender returns whether addr is a known correspondent of the account: an
+// email address of a contact, including contacts harvested from sent messages, or
+// a recipient of a message in a Sent mailbox. Known senders are treated more
+// leniently during delivery.
+func (a *Account) KnownSender(tx *bstore.Tx, addr smtp.Address) (bool, error) {
+	exists, err := bstore.QueryTx[Contact](tx).FilterIn("Emails", addr.String()).Exists()
+	if err != nil || exists {
+		return exists, err
+	}
+	q := bstore.QueryTx[Recipient](tx)
+	q.FilterNonzero(Recipient{Domain: addr.Domain.Name(), Localpart: addr.Localpart.String()})
+	return q.Exists()
+}
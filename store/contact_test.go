@@ -0,0 +1,157 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/smtp"
+)
+
+func tcompare(t *testing.T, got, exp any) {
+	t.Helper()
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("got %#v, expected %#v", got, exp)
+	}
+}
+
+func TestContacts(t *testing.T) {
+	log := mlog.New("store", nil)
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	acc, err := OpenAccount(log, "mjl")
+	tcheck(t, err, "open account")
+	defer func() {
+		err = acc.Close()
+		tcheck(t, err, "closing account")
+		acc.CheckClosed()
+	}()
+	defer Switchboard()()
+
+	_, err = acc.ContactSave(ctxbg, Contact{})
+	if !errors.Is(err, ErrContactParam) {
+		t.Fatalf("saving empty contact, got err %v, expected ErrContactParam", err)
+	}
+	_, err = acc.ContactSave(ctxbg, Contact{Emails: []string{"bogus"}})
+	if !errors.Is(err, ErrContactParam) {
+		t.Fatalf("saving contact with bad email address, got err %v, expected ErrContactParam", err)
+	}
+
+	c, err := acc.ContactSave(ctxbg, Contact{Name: "Mox Test", Emails: []string{" mox@MOX.example", ""}, Organization: "Mox; Inc"})
+	tcheck(t, err, "save contact")
+	tcompare(t, c.Emails, []string{"mox@mox.example"})
+	if c.UID == "" || c.Href != c.UID+".vcf" {
+		t.Fatalf("bad uid/href %q %q", c.UID, c.Href)
+	}
+	card := string(c.CardData())
+	for _, s := range []string{"FN:Mox Test\r\n", "N:Test;Mox;;;\r\n", "EMAIL:mox@mox.example\r\n", `ORG:Mox\; Inc` + "\r\n", "UID:" + c.UID + "\r\n"} {
+		if !strings.Contains(card, s) {
+			t.Fatalf("vcard %q does not contain %q", card, s)
+		}
+	}
+
+	// Update through CardDAV, with property we don't know about, and precondition checks.
+	vcf := "BEGIN:VCARD\r\nVERSION:3.0\r\nUID:" + c.UID + "\r\nFN:Mox Test\r\nEMAIL:mox@mox.example\r\nBDAY:2000-01-01\r\nEND:VCARD\r\n"
+	_, _, err = acc.ContactPut(ctxbg, c.Href, []byte(vcf), `"bogus"`, "")
+	if !errors.Is(err, ErrContactPrecondition) {
+		t.Fatalf("put with bad if-match, got err %v, expected ErrContactPrecondition", err)
+	}
+	_, _, err = acc.ContactPut(ctxbg, c.Href, []byte(vcf), "", "*")
+	if !errors.Is(err, ErrContactPrecondition) {
+		t.Fatalf("put with if-none-match for existing contact, got err %v, expected ErrContactPrecondition", err)
+	}
+	nc, created, err := acc.ContactPut(ctxbg, c.Href, []byte(vcf), c.ETag(), "")
+	tcheck(t, err, "put contact")
+	tcompare(t, created, false)
+	tcompare(t, nc.ID, c.ID)
+	tcompare(t, nc.Organization, "")
+
+	// Saving from web interface keeps unknown properties.
+	nc.Phones = []string{"+1 555"}
+	nc, err = acc.ContactSave(ctxbg, nc)
+	tcheck(t, err, "save contact")
+	card = string(nc.CardData())
+	if !strings.Contains(card, "BDAY:2000-01-01\r\n") || !strings.Contains(card, "TEL:+1 555\r\n") {
+		t.Fatalf("unexpected vcard %q", card)
+	}
+
+	// New contact through CardDAV.
+	_, _, err = acc.ContactPut(ctxbg, "new.vcf", []byte("BEGIN:VCARD\r\nFN:New\r\nEND:VCARD\r\n"), `"x"`, "")
+	if !errors.Is(err, ErrContactPrecondition) {
+		t.Fatalf("put new with if-match, got err %v, expected ErrContactPrecondition", err)
+	}
+	_, _, err = acc.ContactPut(ctxbg, "new.vcf", []byte("bogus"), "", "")
+	if !errors.Is(err, ErrContactParam) {
+		t.Fatalf("put bad vcard, got err %v, expected ErrContactParam", err)
+	}
+	_, created, err = acc.ContactPut(ctxbg, "new.vcf", []byte("BEGIN:VCARD\r\nFN:New\r\nEND:VCARD\r\n"), "", "*")
+	tcheck(t, err, "put new contact")
+	tcompare(t, created, true)
+
+	// Export and import again, updating existing contacts by UID and adding new.
+	var buf bytes.Buffer
+	err = acc.ContactsExport(ctxbg, &buf)
+	tcheck(t, err, "export")
+	buf.WriteString("BEGIN:VCARD\r\nFN:Imported\r\nEMAIL:imported@mox.example\r\nEND:VCARD\r\n")
+	n, err := acc.ContactsImport(ctxbg, &buf)
+	tcheck(t, err, "import")
+	tcompare(t, n, 3)
+	l, err := acc.Contacts(ctxbg)
+	tcheck(t, err, "list contacts")
+	tcompare(t, len(l), 3)
+	tcompare(t, l[0].Name, "Imported")
+
+	_, err = acc.ContactsImport(ctxbg, strings.NewReader("BEGIN:VCARD\r\n"))
+	if !errors.Is(err, ErrContactParam) {
+		t.Fatalf("import bad vcard, got err %v, expected ErrContactParam", err)
+	}
+
+	// Recipients of messages delivered to the Sent mailbox are harvested, if not
+	// already a contact.
+	msgFile, err := CreateMessageTemp(log, "contact-test")
+	tcheck(t, err, "create temp message")
+	defer os.Remove(msgFile.Name())
+	defer msgFile.Close()
+	msg := "From: <mjl@mox.example>\r\nTo: Other <other@mox.example>, <mox@mox.example>\r\nSubject: test\r\n\r\ntest\r\n"
+	_, err = msgFile.Write([]byte(msg))
+	tcheck(t, err, "write message")
+	m := Message{Size: int64(len(msg))}
+	acc.WithWLock(func() {
+		err = acc.DeliverMailbox(log, "Sent", &m, msgFile)
+	})
+	tcheck(t, err, "deliver to sent")
+	l, err = acc.Contacts(ctxbg)
+	tcheck(t, err, "list contacts")
+	tcompare(t, len(l), 4)
+	tcompare(t, l[3].Name, "Other")
+	tcompare(t, l[3].Emails, []string{"other@mox.example"})
+	tcompare(t, l[3].Harvested, true)
+
+	err = acc.ContactRemove(ctxbg, l[3].ID)
+	tcheck(t, err, "remove contact")
+
+	// Contacts and recipients of sent messages are known senders.
+	knownSender := func(addr string, expect bool) {
+		t.Helper()
+		a, err := smtp.ParseAddress(addr)
+		tcheck(t, err, "parse address")
+		err = acc.DB.Read(ctxbg, func(tx *bstore.Tx) error {
+			known, err := acc.KnownSender(tx, a)
+			tcompare(t, known, expect)
+			return err
+		})
+		tcheck(t, err, "known sender")
+	}
+	knownSender("imported@mox.example", true)
+	knownSender("other@mox.example", true) // Contact removed, but still a recipient.
+	knownSender("stranger@mox.example", false)
+}
@@ -0,0 +1,233 @@
+package store
+
+// Deduplication of message files.
+//
+// Identical message files, e.g. of a message delivered to many accounts, are
+// stored once by hard linking them. Deduplicated message files are also linked
+// from a content-addressed "blobs" directory in the data directory, named after
+// the SHA-256 hash of the file contents. When a new message file has the same
+// contents as an existing blob, it is replaced with a link to the blob. The file
+// system keeps a reference count: When all messages linking to a blob have been
+// removed, only the link from the blobs directory remains, and the blob is removed
+// by DedupCleanup.
+//
+// Encrypted message files are unique per account and are not deduplicated.
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"time"
+
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/moxio"
+)
+
+func blobPath(sum []byte) string {
+	h := hex.EncodeToString(sum)
+	return mox.DataDirPath(filepath.Join("blobs", h[:2], h))
+}
+
+// dedupMessageFile links the message file at path to its blob, or replaces it
+// with a link to an existing blob with the same contents. The path to the blob is
+// returned, and whether the file was replaced. Encrypted files are skipped, with
+// an empty blob path.
+func dedupMessageFile(log mlog.Log, path string, encrypted bool) (blob string, replaced bool, rerr error) {
+	if encrypted {
+		return "", false, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", false, err
+	}
+	defer func() {
+		err := f.Close()
+		log.Check(err, "closing message file")
+	}()
+	fi, err := f.Stat()
+	if err != nil {
+		return "", false, err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", false, fmt.Errorf("hashing message file: %v", err)
+	}
+	bp := blobPath(h.Sum(nil))
+
+	blobDir := filepath.Dir(bp)
+	bfi, err := os.Stat(bp)
+	if errors.Is(err, fs.ErrNotExist) {
+		if err := os.MkdirAll(blobDir, 0770); err != nil {
+			return "", false, fmt.Errorf("making blob directory: %v", err)
+		}
+		if lerr := os.Link(path, bp); lerr == nil {
+			if err := moxio.SyncDir(log, blobDir); err != nil {
+				return "", false, fmt.Errorf("sync blob directory: %v", err)
+			}
+			return bp, false, nil
+		} else if !errors.Is(lerr, fs.ErrExist) {
+			return "", false, lerr
+		}
+		// Added concurrently, link to it instead.
+		bfi, err = os.Stat(bp)
+	}
+	if err != nil {
+		return "", false, err
+	}
+	if os.SameFile(fi, bfi) {
+		return bp, false, nil
+	}
+	if fi.Size() != bfi.Size() {
+		return "", false, fmt.Errorf("blob %s has size %d, message file %s has size %d", bp, bfi.Size(), path, fi.Size())
+	}
+
+	// Replace atomically, readers with the original file open can continue reading.
+	tmpPath := path + ".dedup"
+	err = os.Remove(tmpPath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Errorx("removing leftover temporary file", err, slog.String("path", tmpPath))
+	}
+	if err := os.Link(bp, tmpPath); err != nil {
+		return "", false, err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		xerr := os.Remove(tmpPath)
+		log.Check(xerr, "removing temporary link to blob")
+		return "", false, err
+	}
+	// Sync both directories, so the links to the blob are durable.
+	if err := moxio.SyncDir(log, blobDir); err != nil {
+		return "", false, fmt.Errorf("sync blob directory: %v", err)
+	}
+	if err := moxio.SyncDir(log, filepath.Dir(path)); err != nil {
+		return "", false, fmt.Errorf("sync message directory: %v", err)
+	}
+	return bp, true, nil
+}
+
+// DedupStats holds statistics about deduplicated message files of an account.
+type DedupStats struct {
+	Messages   int   // Number of message files.
+	Replaced   int   // Number of message files replaced with a link to an identical file.
+	Size       int64 // Total size of message files on disk.
+	Attributed int64 // Size of message files on disk, with shared files divided by the number of messages sharing them.
+}
+
+// DedupMessageFiles deduplicates all message files of the account, e.g. files
+// stored before deduplication was enabled. Messages are processed in batches,
+// each with the account write lock held. Statistics about shared storage are
+// gathered after deduplicating all files, so the number of messages sharing a
+// file is known.
+func (a *Account) DedupMessageFiles(ctx context.Context, log mlog.Log) (DedupStats, error) {
+	var stats DedupStats
+	err := a.forEachMessageFile(ctx, func(m Message, p string) error {
+		_, replaced, err := dedupMessageFile(log, p, m.FileEncrypted)
+		if err != nil {
+			return fmt.Errorf("deduplicating message file for message id %d: %v", m.ID, err)
+		}
+		if replaced {
+			stats.Replaced++
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	err = a.forEachMessageFile(ctx, func(m Message, p string) error {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return err
+		}
+		refs, err := fileLinks(fi)
+		if err != nil {
+			return err
+		}
+		// Don't count the link from the blobs directory.
+		if refs > 1 {
+			refs--
+		}
+		stats.Messages++
+		stats.Size += fi.Size()
+		stats.Attributed += fi.Size() / refs
+		return nil
+	})
+	return stats, err
+}
+
+// DedupCleanup removes blobs that are no longer linked from message files. The
+// number of blobs removed and their total size are returned.
+func DedupCleanup(log mlog.Log) (removed int, size int64, rerr error) {
+	dir := mox.DataDirPath("blobs")
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == dir && errors.Is(err, fs.ErrNotExist) {
+				return fs.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		refs, err := fileLinks(fi)
+		if err != nil {
+			return err
+		}
+		if refs > 1 {
+			return nil
+		}
+		// A message file may be linked to the blob concurrently. The link will fail and
+		// the message file is kept as is.
+		if err := os.Remove(p); err != nil {
+			return err
+		}
+		removed++
+		size += fi.Size()
+		return nil
+	})
+	return removed, size, err
+}
+
+// StartDedupCleanup periodically removes blobs that are no longer used.
+func StartDedupCleanup() {
+	log := mlog.New("store", nil)
+
+	go func() {
+		defer func() {
+			x := recover()
+			if x != nil {
+				log.Error("recover from panic", slog.Any("panic", x))
+				debug.PrintStack()
+				metrics.PanicInc(metrics.Store)
+			}
+		}()
+
+		for {
+			select {
+			case <-mox.Shutdown.Done():
+				return
+			case <-time.After(24 * time.Hour):
+			}
+
+			removed, size, err := DedupCleanup(log)
+			log.Check(err, "removing unused deduplicated message files")
+			if removed > 0 {
+				log.Info("removed unused deduplicated message files", slog.Int("count", removed), slog.Int64("size", size))
+			}
+		}
+	}()
+}
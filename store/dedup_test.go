@@ -0,0 +1,98 @@
+//go:build !windows
+
+package store
+
+import (
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/mox-"
+)
+
+func TestDedup(t *testing.T) {
+	log := pkglog
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	defer func() {
+		mox.Conf.Static.DeduplicateMessages = false
+	}()
+	acc, err := OpenAccount(log, "mjl")
+	tcheck(t, err, "open account")
+	defer func() {
+		err = acc.Close()
+		tcheck(t, err, "closing account")
+		acc.CheckClosed()
+	}()
+	defer Switchboard()()
+
+	const msg = "Subject: announcement\r\n\r\nto everyone\r\n"
+	deliver := func() Message {
+		t.Helper()
+		msgFile, err := CreateMessageTemp(log, "dedup-test")
+		tcheck(t, err, "create temp message")
+		defer os.Remove(msgFile.Name())
+		defer msgFile.Close()
+		_, err = msgFile.Write([]byte(msg))
+		tcheck(t, err, "write message")
+		m := Message{Received: time.Now(), Size: int64(len(msg))}
+		acc.WithWLock(func() {
+			err = acc.DeliverMailbox(log, "Inbox", &m, msgFile)
+		})
+		tcheck(t, err, "deliver message")
+		return m
+	}
+	sameFile := func(a, b Message) bool {
+		t.Helper()
+		fa, err := os.Stat(acc.MessagePath(a.ID))
+		tcheck(t, err, "stat")
+		fb, err := os.Stat(acc.MessagePath(b.ID))
+		tcheck(t, err, "stat")
+		return os.SameFile(fa, fb)
+	}
+	countBlobs := func() int {
+		t.Helper()
+		var n int
+		filepath.WalkDir(mox.DataDirPath("blobs"), func(p string, d os.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				n++
+			}
+			return nil
+		})
+		return n
+	}
+
+	// Existing files, deduplicated afterwards.
+	m0 := deliver()
+	m1 := deliver()
+	tcompare(t, sameFile(m0, m1), false)
+	stats, err := acc.DedupMessageFiles(ctxbg, log)
+	tcheck(t, err, "dedup message files")
+	tcompare(t, stats.Messages, 2)
+	tcompare(t, stats.Replaced, 1)
+	tcompare(t, stats.Size, 2*int64(len(msg)))
+	tcompare(t, stats.Attributed, int64(len(msg)))
+	tcompare(t, sameFile(m0, m1), true)
+
+	// New deliveries are linked to the blob.
+	mox.Conf.Static.DeduplicateMessages = true
+	m2 := deliver()
+	tcompare(t, sameFile(m0, m2), true)
+	tcompare(t, countBlobs(), 1)
+
+	// Only blobs no longer used by messages are removed.
+	sum := sha256.Sum256([]byte("unused"))
+	unused := blobPath(sum[:])
+	os.MkdirAll(filepath.Dir(unused), 0770)
+	err = os.WriteFile(unused, []byte("unused"), 0660)
+	tcheck(t, err, "write unused blob")
+	removed, size, err := DedupCleanup(log)
+	tcheck(t, err, "cleanup")
+	tcompare(t, removed, 1)
+	tcompare(t, size, int64(len("unused")))
+	tcompare(t, countBlobs(), 1)
+	tcompare(t, sameFile(m0, m2), true)
+}
@@ -0,0 +1,214 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/moxio"
+)
+
+var metricDeliverGroup = promauto.NewHistogram(
+	prometheus.HistogramOpts{
+		Name:    "mox_store_deliver_group_size",
+		Help:    "Number of deliveries committed together in a single database transaction.",
+		Buckets: []float64{1, 2, 4, 8, 16, 32, 64},
+	},
+)
+
+// Maximum number of deliveries committed in a single transaction. Limits the time
+// the account write lock is held.
+const deliverGroupMax = 64
+
+// deliverRequest is a delivery waiting to be committed, see DeliverGroup.
+type deliverRequest struct {
+	log     mlog.Log
+	mailbox string
+	m       *Message
+	msgFile *os.File
+	lead    chan struct{} // Receives when the request must commit pending deliveries.
+	done    chan error    // Receives the result of the delivery.
+}
+
+// DeliverGroup delivers m to mailbox, like DeliverMailbox, but commits the
+// delivery together with concurrent deliveries to the same account in a single
+// database transaction ("group commit"). Deliveries that arrive while a
+// transaction is being committed wait and are committed in the next transaction,
+// so a burst of deliveries, e.g. from a mailing list, doesn't serialize on an
+// fsync per message.
+//
+// With the default "full" DeliveryDurability, the message file is synced before
+// waiting, and the directories of the message files are synced once for all
+// deliveries in the transaction. With "relaxed", they aren't synced.
+//
+// If the combined transaction fails, e.g. because one of the messages would put
+// the account over quota, the deliveries are retried one by one so only the
+// failing delivery returns an error.
+//
+// Caller must not hold the account wlock, it is taken while committing.
+// Message delivery, possible mailbox creation, and updated mailbox counts are
+// broadcasted.
+func (a *Account) DeliverGroup(log mlog.Log, mailbox string, m *Message, msgFile *os.File) error {
+	sync := mox.Conf.Static.DeliveryDurability != "relaxed"
+	if sync {
+		// Syncing outside of the lock lets concurrent deliveries sync in parallel.
+		if err := msgFile.Sync(); err != nil {
+			return fmt.Errorf("fsync message file: %w", err)
+		}
+	}
+
+	req := &deliverRequest{log, mailbox, m, msgFile, make(chan struct{}, 1), make(chan error, 1)}
+	a.deliverGroup.Lock()
+	a.deliverGroup.pending = append(a.deliverGroup.pending, req)
+	if !a.deliverGroup.busy {
+		a.deliverGroup.busy = true
+		req.lead <- struct{}{}
+	}
+	a.deliverGroup.Unlock()
+
+	for {
+		select {
+		case err := <-req.done:
+			return err
+		case <-req.lead:
+			a.deliverGroupCommit(log, sync)
+		}
+	}
+}
+
+// deliverGroupCommit commits the pending deliveries, and hands off committing
+// the deliveries that arrive in the meantime to the first of them. On a panic,
+// the deliveries in the batch that have not yet been answered get an error, so
+// they don't wait forever, and the panic is passed on.
+func (a *Account) deliverGroupCommit(log mlog.Log, sync bool) {
+	a.deliverGroup.Lock()
+	n := min(len(a.deliverGroup.pending), deliverGroupMax)
+	batch := a.deliverGroup.pending[:n:n]
+	a.deliverGroup.pending = a.deliverGroup.pending[n:]
+	a.deliverGroup.Unlock()
+
+	var answered int   // Requests in batch that got their result, in order.
+	var paths []string // Message files written in group transaction.
+	var committed bool // Whether group transaction was committed.
+	defer func() {
+		x := recover()
+		if x != nil {
+			log.Error("panic during group commit of deliveries", slog.Any("panic", x), slog.Int("deliveries", len(batch)), slog.Int("answered", answered))
+			if !committed {
+				for _, p := range paths {
+					err := os.Remove(p)
+					log.Check(err, "removing message file after panic in group commit", slog.String("path", p))
+				}
+			}
+			for _, r := range batch[answered:] {
+				r.done <- fmt.Errorf("delivery aborted due to panic during group commit: %v", x)
+			}
+		}
+
+		a.deliverGroup.Lock()
+		if len(a.deliverGroup.pending) > 0 {
+			a.deliverGroup.pending[0].lead <- struct{}{}
+		} else {
+			a.deliverGroup.busy = false
+		}
+		a.deliverGroup.Unlock()
+
+		if x != nil {
+			panic(x)
+		}
+	}()
+
+	metricDeliverGroup.Observe(float64(len(batch)))
+
+	if len(batch) == 1 {
+		r := batch[0]
+		var err error
+		a.WithWLock(func() {
+			err = a.deliverMailbox(r.log, r.mailbox, r.m, r.msgFile, sync)
+		})
+		r.done <- err
+		answered++
+		return
+	}
+
+	// Keep the original messages, to restore them when the transaction fails.
+	origs := make([]Message, len(batch))
+	for i, r := range batch {
+		origs[i] = *r.m
+	}
+	var err error
+	a.WithWLock(func() {
+		var changes []Change
+		err = a.DB.Write(context.TODO(), func(tx *bstore.Tx) error {
+			dirs := map[string]struct{}{}
+			for _, r := range batch {
+				// We train after all messages have been added, so a failing delivery doesn't leave
+				// the junk filter trained for messages that weren't delivered.
+				chl, err := a.deliverMailboxTx(r.log, tx, r.mailbox, r.m, r.msgFile, false, true)
+				if err != nil {
+					return err
+				}
+				changes = append(changes, chl...)
+				p := a.MessagePath(r.m.ID)
+				paths = append(paths, p)
+				dirs[filepath.Dir(p)] = struct{}{}
+			}
+
+			if sync {
+				for dir := range dirs {
+					if err := moxio.SyncDir(log, dir); err != nil {
+						return fmt.Errorf("sync directory: %w", err)
+					}
+				}
+			}
+
+			msgs := make([]Message, len(batch))
+			for i, r := range batch {
+				msgs[i] = *r.m
+			}
+			if err := a.RetrainMessages(context.TODO(), log, tx, msgs, false); err != nil {
+				return fmt.Errorf("training junkfilter: %w", err)
+			}
+			for i, r := range batch {
+				*r.m = msgs[i]
+			}
+			return nil
+		})
+		if err == nil {
+			BroadcastChanges(a, changes)
+		}
+	})
+	if err == nil {
+		committed = true
+		for _, r := range batch {
+			r.done <- nil
+			answered++
+		}
+		return
+	}
+
+	log.Infox("group commit of deliveries failed, delivering one by one", err, slog.Int("deliveries", len(batch)))
+	for _, p := range paths {
+		err := os.Remove(p)
+		log.Check(err, "removing message file after failed group commit", slog.String("path", p))
+	}
+	paths = nil
+	for i, r := range batch {
+		*r.m = origs[i]
+		var err error
+		a.WithWLock(func() {
+			err = a.deliverMailbox(r.log, r.mailbox, r.m, r.msgFile, sync)
+		})
+		r.done <- err
+		answered++
+	}
+}
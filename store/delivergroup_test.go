@@ -0,0 +1,129 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mox-"
+)
+
+func TestDeliverGroup(t *testing.T) {
+	log := pkglog
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	acc, err := OpenAccount(log, "mjl")
+	tcheck(t, err, "open account")
+	defer func() {
+		err = acc.Close()
+		tcheck(t, err, "closing account")
+		acc.CheckClosed()
+	}()
+	defer Switchboard()()
+
+	const msg = "Subject: hi\r\n\r\nbody\r\n"
+	xmessage := func() (*Message, *os.File) {
+		msgFile, err := CreateMessageTemp(log, "delivergroup-test")
+		tcheck(t, err, "create temp message")
+		_, err = msgFile.Write([]byte(msg))
+		tcheck(t, err, "write message")
+		return &Message{Received: time.Now(), Size: int64(len(msg))}, msgFile
+	}
+	xclose := func(msgFile *os.File) {
+		os.Remove(msgFile.Name())
+		msgFile.Close()
+	}
+
+	// Concurrent deliveries.
+	const n = 20
+	var wg sync.WaitGroup
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			m, msgFile := xmessage()
+			defer xclose(msgFile)
+			errs[i] = acc.DeliverGroup(log, "Inbox", m, msgFile)
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		tcheck(t, err, "deliver")
+	}
+	tcompare(t, acc.deliverGroup.busy, false)
+
+	countInbox := func() int {
+		mb, err := bstore.QueryDB[Mailbox](ctxbg, acc.DB).FilterNonzero(Mailbox{Name: "Inbox"}).Get()
+		tcheck(t, err, "get inbox")
+		return int(mb.Total)
+	}
+	tcompare(t, countInbox(), n)
+	err = acc.CheckConsistency()
+	tcheck(t, err, "check consistency")
+
+	// A failing delivery in a group doesn't fail the other deliveries.
+	var reqs []*deliverRequest
+	for _, mailbox := range []string{"Inbox", "INBOX", "Inbox"} {
+		m, msgFile := xmessage()
+		defer xclose(msgFile)
+		reqs = append(reqs, &deliverRequest{log, mailbox, m, msgFile, make(chan struct{}, 1), make(chan error, 1)})
+	}
+	acc.deliverGroup.pending = reqs
+	acc.deliverGroup.busy = true
+	acc.deliverGroupCommit(log, true)
+	tcompare(t, acc.deliverGroup.busy, false)
+	tcheck(t, <-reqs[0].done, "deliver first")
+	if err := <-reqs[1].done; err == nil {
+		t.Fatalf("expected error for bad mailbox name")
+	}
+	tcheck(t, <-reqs[2].done, "deliver third")
+	tcompare(t, countInbox(), n+2)
+	for _, r := range []*deliverRequest{reqs[0], reqs[2]} {
+		_, err := os.Stat(acc.MessagePath(r.m.ID))
+		tcheck(t, err, "stat delivered message file")
+	}
+	err = acc.CheckConsistency()
+	tcheck(t, err, "check consistency")
+
+	// A panic during a group commit fails all deliveries in the group, and passes on
+	// leadership.
+	reqs = nil
+	for i := 0; i < 4; i++ {
+		m, msgFile := xmessage()
+		defer xclose(msgFile)
+		if i == 1 {
+			m = nil
+		}
+		reqs = append(reqs, &deliverRequest{log, "Inbox", m, msgFile, make(chan struct{}, 1), make(chan error, 1)})
+	}
+	acc.deliverGroup.pending = reqs
+	acc.deliverGroup.busy = true
+	func() {
+		defer func() {
+			if x := recover(); x == nil {
+				t.Fatalf("expected panic")
+			}
+		}()
+		acc.deliverGroupCommit(log, true)
+	}()
+	tcompare(t, acc.deliverGroup.busy, false)
+	for _, r := range reqs {
+		select {
+		case err := <-r.done:
+			if err == nil {
+				t.Fatalf("expected error for delivery in group with panic")
+			}
+		default:
+			t.Fatalf("delivery in group with panic did not get result")
+		}
+	}
+	tcompare(t, countInbox(), n+2)
+	err = acc.CheckConsistency()
+	tcheck(t, err, "check consistency")
+}
@@ -0,0 +1,331 @@
+package store
+
+// Encryption at rest of message files.
+//
+// Whether a message file is encrypted is recorded in the database, in
+// Message.FileEncrypted. It is not detected from the file contents: messages can
+// contain arbitrary data, including the magic value of encrypted files.
+//
+// Encrypted message files start with a header of a magic value and a random salt.
+// The remainder of the file consists of chunks of up to 64KB of plaintext,
+// encrypted with AES-256-GCM, each followed by its authentication tag. The key for
+// a file is derived from the account key and the salt of the file, and the
+// account key is derived from the configured master key and the account name.
+// The nonce for a chunk is its index in the file, with a flag for the last chunk,
+// so a file truncated at a chunk boundary does not decrypt. A file always has a
+// last chunk, for an empty message it has no data. Chunks allow random access
+// without decrypting the whole file.
+//
+// Headers, structure and addresses of messages in the account database are
+// encrypted too, see sealed.go. Data needed for indexed lookups, such as sender
+// domains and IPs for reputation, and mailbox names, contacts, the junk filter
+// database, and the queue database and messages in the queue, are stored in plain
+// text.
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/mjl-/mox/mox-"
+)
+
+// Magic value at start of encrypted message files. Only used to detect
+// inconsistencies with the database.
+const encMagic = "\x00moxenc1"
+
+const (
+	encSaltSize   = 16
+	encHeaderSize = len(encMagic) + encSaltSize
+	encChunkSize  = 64 * 1024
+	encTagSize    = 16
+)
+
+// ErrNoMessageKey is returned when reading an encrypted message file while no
+// encryption key is configured.
+var ErrNoMessageKey = errors.New("message file is encrypted but no encryption key is configured")
+
+// messageKey returns the key for encrypting message files of the account, or nil
+// if encryption at rest is not configured.
+func messageKey(accountName string) []byte {
+	if mox.Conf.Static.MessageEncryption == nil || len(mox.Conf.Static.MessageEncryption.Key) == 0 {
+		return nil
+	}
+	mac := hmac.New(sha256.New, mox.Conf.Static.MessageEncryption.Key)
+	mac.Write([]byte("mox message encryption account key\x00" + accountName))
+	return mac.Sum(nil)
+}
+
+func fileAEAD(key, salt []byte) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(salt)
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(aead cipher.AEAD, index int64, last bool) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	if last {
+		nonce[0] = 1
+	}
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], uint64(index))
+	return nonce
+}
+
+// writeMessageFile writes the contents of r to a new file at path, compressed
+// if compress is set, and encrypted with key if not nil.
+func writeMessageFile(path string, r io.Reader, key []byte, compress, sync bool) (rerr error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0660)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+		if rerr != nil {
+			os.Remove(path)
+		}
+	}()
+
+	var w io.Writer = f
+	var ew *encryptWriter
+	if key != nil {
+		salt := make([]byte, encSaltSize)
+		cryptorand.Read(salt)
+		aead, err := fileAEAD(key, salt)
+		if err != nil {
+			return fmt.Errorf("initializing encryption: %v", err)
+		}
+		if _, err := f.Write(append([]byte(encMagic), salt...)); err != nil {
+			return fmt.Errorf("writing header: %v", err)
+		}
+		ew = &encryptWriter{w: f, aead: aead}
+		w = ew
+	}
+	if compress {
+		err = writeCompressed(w, r)
+	} else {
+		_, err = io.Copy(w, r)
+	}
+	if err != nil {
+		return err
+	}
+	if ew != nil {
+		if err := ew.flush(true); err != nil {
+			return err
+		}
+	}
+	if sync {
+		if err := f.Sync(); err != nil {
+			return fmt.Errorf("sync: %v", err)
+		}
+	}
+	err = f.Close()
+	f = nil
+	return err
+}
+
+// encryptWriter encrypts data in chunks. A full chunk is only written when more
+// data follows. The last, possibly partial or empty, chunk is written by flush.
+type encryptWriter struct {
+	w     io.Writer
+	aead  cipher.AEAD
+	index int64
+	buf   []byte
+	out   []byte
+}
+
+func (e *encryptWriter) Write(buf []byte) (int, error) {
+	var n int
+	for len(buf) > 0 {
+		if e.buf == nil {
+			e.buf = make([]byte, 0, encChunkSize)
+		}
+		if len(e.buf) == encChunkSize {
+			if err := e.flush(false); err != nil {
+				return n, err
+			}
+		}
+		o := copy(e.buf[len(e.buf):encChunkSize], buf)
+		e.buf = e.buf[:len(e.buf)+o]
+		buf = buf[o:]
+		n += o
+	}
+	return n, nil
+}
+
+func (e *encryptWriter) flush(last bool) error {
+	e.out = e.aead.Seal(e.out[:0], chunkNonce(e.aead, e.index, last), e.buf, nil)
+	if _, err := e.w.Write(e.out); err != nil {
+		return fmt.Errorf("writing chunk: %v", err)
+	}
+	e.index++
+	e.buf = e.buf[:0]
+	return nil
+}
+
+// encryptedChunks returns the number of chunks and the size of the plaintext of
+// an encrypted file of size. An error is returned if the size is not valid.
+func encryptedChunks(size int64) (chunks, plain int64, rerr error) {
+	n := size - int64(encHeaderSize)
+	chunks = (n + encChunkSize + encTagSize - 1) / (encChunkSize + encTagSize)
+	if n < encTagSize || n-(chunks-1)*(encChunkSize+encTagSize) < encTagSize {
+		return 0, 0, fmt.Errorf("bad size %d for encrypted message file", size)
+	}
+	return chunks, n - chunks*encTagSize, nil
+}
+
+// encryptedSalt reads the header of an encrypted message file, returning the salt.
+func encryptedSalt(f *os.File) ([]byte, error) {
+	buf := make([]byte, encHeaderSize)
+	n, err := f.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if n < encHeaderSize || string(buf[:len(encMagic)]) != encMagic {
+		return nil, fmt.Errorf("message file is not encrypted, inconsistent with database")
+	}
+	return buf[len(encMagic):], nil
+}
+
+// MessageFileSize returns the size of the message stored in the file at path,
+// i.e. the decrypted and decompressed size. Encrypted and compressed describe the
+// format of the file, see Message.FileEncrypted and Message.FileCompressed. For
+// encrypted files, key must be set, otherwise ErrNoMessageKey is returned.
+func MessageFileSize(path string, key []byte, encrypted, compressed bool) (int64, error) {
+	mf, size, err := openMessageFile(path, key, encrypted, compressed)
+	if err != nil {
+		return 0, err
+	}
+	err = mf.Close()
+	return size, err
+}
+
+// MessageKey returns the key for message files of the account, or nil if
+// encryption at rest is not configured.
+func MessageKey(accountName string) []byte {
+	return messageKey(accountName)
+}
+
+// messageFile is an opened on-disk message file, possibly encrypted and/or
+// compressed.
+type messageFile interface {
+	io.ReaderAt
+	io.Closer
+}
+
+// openMessageFile opens the message file at path, transparently decrypting it
+// with key if encrypted is set, and decompressing it if compressed is set. Plain
+// files, e.g. those written before encryption or compression was enabled, are
+// returned as is. The size of the message in the file is returned. For encrypted
+// files, the last chunk is verified, so truncated files result in an error.
+func openMessageFile(path string, key []byte, encrypted, compressed bool) (mf messageFile, size int64, rerr error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() {
+		if rerr != nil {
+			f.Close()
+		}
+	}()
+	st, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	mf = f
+	size = st.Size()
+
+	if encrypted {
+		if key == nil {
+			return nil, 0, ErrNoMessageKey
+		}
+		salt, err := encryptedSalt(f)
+		if err != nil {
+			return nil, 0, err
+		}
+		aead, err := fileAEAD(key, salt)
+		if err != nil {
+			return nil, 0, fmt.Errorf("initializing decryption: %v", err)
+		}
+		var chunks int64
+		chunks, size, err = encryptedChunks(st.Size())
+		if err != nil {
+			return nil, 0, err
+		}
+		df := &decryptFile{f: f, aead: aead, size: size, chunks: chunks, chunk: -1}
+		if err := df.load(chunks - 1); err != nil {
+			return nil, 0, err
+		}
+		mf = df
+	}
+
+	if compressed {
+		return openCompressed(mf, size)
+	}
+	return mf, size, nil
+}
+
+// decryptFile provides random access to the plaintext of an encrypted message
+// file. The most recently decrypted chunk is kept.
+type decryptFile struct {
+	f      *os.File
+	aead   cipher.AEAD
+	size   int64 // Of plaintext.
+	chunks int64 // Number of chunks in file.
+	chunk  int64 // Index of chunk in buf, -1 if none.
+	buf    []byte
+	ebuf   []byte
+}
+
+func (d *decryptFile) load(index int64) error {
+	if d.chunk == index {
+		return nil
+	}
+	if d.ebuf == nil {
+		d.ebuf = make([]byte, encChunkSize+encTagSize)
+	}
+	n, err := d.f.ReadAt(d.ebuf, int64(encHeaderSize)+index*(encChunkSize+encTagSize))
+	if err != nil && err != io.EOF {
+		return err
+	}
+	d.chunk = -1
+	d.buf, err = d.aead.Open(d.buf[:0], chunkNonce(d.aead, index, index == d.chunks-1), d.ebuf[:n], nil)
+	if err != nil {
+		return fmt.Errorf("decrypting message file chunk %d: %v", index, err)
+	}
+	d.chunk = index
+	return nil
+}
+
+func (d *decryptFile) ReadAt(buf []byte, off int64) (int, error) {
+	var o int
+	for o < len(buf) {
+		if off >= d.size {
+			return o, io.EOF
+		}
+		index := off / encChunkSize
+		if err := d.load(index); err != nil {
+			return o, err
+		}
+		n := copy(buf[o:], d.buf[off-index*encChunkSize:])
+		o += n
+		off += int64(n)
+	}
+	return o, nil
+}
+
+func (d *decryptFile) Close() error {
+	return d.f.Close()
+}
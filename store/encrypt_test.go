@@ -0,0 +1,129 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/mox-"
+)
+
+func TestMessageEncryption(t *testing.T) {
+	log := pkglog
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	mox.Conf.Static.MessageEncryption = &config.MessageEncryption{Key: bytes.Repeat([]byte{1}, 32)}
+	defer func() {
+		mox.Conf.Static.MessageEncryption = nil
+	}()
+	acc, err := OpenAccount(log, "mjl")
+	tcheck(t, err, "open account")
+	defer func() {
+		err = acc.Close()
+		tcheck(t, err, "closing account")
+		acc.CheckClosed()
+	}()
+	defer Switchboard()()
+
+	// Message spanning multiple chunks.
+	msg := "Subject: encrypted\r\n\r\n" + strings.Repeat("secret body line\r\n", 10000)
+	msgFile, err := CreateMessageTemp(log, "encrypt-test")
+	tcheck(t, err, "create temp message")
+	defer os.Remove(msgFile.Name())
+	defer msgFile.Close()
+	_, err = msgFile.Write([]byte(msg))
+	tcheck(t, err, "write message")
+
+	prefix := []byte("Received: from localhost\r\n")
+	m := Message{Received: time.Now(), Size: int64(len(prefix) + len(msg)), Sealed: MessageSealed{MsgPrefix: prefix}}
+	acc.WithWLock(func() {
+		err = acc.DeliverMailbox(log, "Inbox", &m, msgFile)
+	})
+	tcheck(t, err, "deliver message")
+
+	p := acc.MessagePath(m.ID)
+	buf, err := os.ReadFile(p)
+	tcheck(t, err, "read message file")
+	tcompare(t, m.FileEncrypted, true)
+	tcompare(t, strings.HasPrefix(string(buf), encMagic), true)
+	tcompare(t, bytes.Contains(buf, []byte("secret")), false)
+	size, err := MessageFileSize(p, messageKey(acc.Name), true, false)
+	tcheck(t, err, "message file size")
+	tcompare(t, size, int64(len(msg)))
+
+	// Transparent decryption, including random access across chunks.
+	msgr := acc.MessageReader(m)
+	data, err := io.ReadAll(msgr)
+	tcheck(t, err, "read message")
+	tcompare(t, string(data), string(prefix)+msg)
+	rbuf := make([]byte, 100)
+	off := int64(len(prefix) + encChunkSize - 50)
+	n, err := msgr.ReadAt(rbuf, off)
+	tcheck(t, err, "readat")
+	tcompare(t, string(rbuf[:n]), msg[off-int64(len(prefix)):off-int64(len(prefix))+100])
+	err = msgr.Close()
+	tcheck(t, err, "close message reader")
+
+	err = acc.CheckConsistency()
+	tcheck(t, err, "check consistency")
+
+	// Tampering is detected.
+	buf[len(buf)-1] ^= 1
+	err = os.WriteFile(p, buf, 0660)
+	tcheck(t, err, "write message file")
+	msgr = acc.MessageReader(m)
+	_, err = io.ReadAll(msgr)
+	if err == nil {
+		t.Fatalf("reading tampered message file succeeded")
+	}
+	msgr.Close()
+	buf[len(buf)-1] ^= 1
+
+	// Truncation at a chunk boundary is detected.
+	err = os.WriteFile(p, buf[:encHeaderSize+encChunkSize+encTagSize], 0660)
+	tcheck(t, err, "write message file")
+	_, err = MessageFileSize(p, messageKey(acc.Name), true, false)
+	if err == nil {
+		t.Fatalf("opening truncated message file succeeded")
+	}
+	err = os.WriteFile(p, buf, 0660)
+	tcheck(t, err, "write message file")
+
+	// Without key, encrypted files cannot be read.
+	enc := mox.Conf.Static.MessageEncryption
+	mox.Conf.Static.MessageEncryption = nil
+	msgr = acc.MessageReader(m)
+	_, err = io.ReadAll(msgr)
+	if !errors.Is(err, ErrNoMessageKey) {
+		t.Fatalf("got err %v, expected ErrNoMessageKey", err)
+	}
+	msgr.Close()
+
+	// Plain message that looks like an encrypted file is read as is.
+	plain := encMagic + strings.Repeat("x", encHeaderSize+encTagSize)
+	_, err = msgFile.Seek(0, 0)
+	tcheck(t, err, "seek message file")
+	err = msgFile.Truncate(0)
+	tcheck(t, err, "truncate message file")
+	_, err = msgFile.Write([]byte(plain))
+	tcheck(t, err, "write message")
+	pm := Message{Received: time.Now(), Size: int64(len(plain))}
+	acc.WithWLock(func() {
+		err = acc.DeliverMailbox(log, "Inbox", &pm, msgFile)
+	})
+	tcheck(t, err, "deliver message")
+	tcompare(t, pm.FileEncrypted, false)
+	mox.Conf.Static.MessageEncryption = enc
+	msgr = acc.MessageReader(pm)
+	data, err = io.ReadAll(msgr)
+	tcheck(t, err, "read plain message")
+	tcompare(t, string(data), plain)
+	msgr.Close()
+}
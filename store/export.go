@@ -6,9 +6,9 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
-	"log/slog"
 	"os"
 	"path"
 	"path/filepath"
@@ -68,6 +68,26 @@ func (a ZipArchiver) Create(name string, size int64, mtime time.Time) (io.WriteC
 	return nopCloser{w}, nil
 }
 
+// prefixArchiver adds a prefix to the names of files added to an archive.
+type prefixArchiver struct {
+	Archiver
+	prefix string
+}
+
+func (a prefixArchiver) Create(name string, size int64, mtime time.Time) (io.WriteCloser, error) {
+	return a.Archiver.Create(a.prefix+name, size, mtime)
+}
+
+// archiverNeedsSize returns whether the archiver needs the exact size of a file
+// when it is created.
+func archiverNeedsSize(a Archiver) bool {
+	if pa, ok := a.(prefixArchiver); ok {
+		a = pa.Archiver
+	}
+	_, ok := a.(TarArchiver)
+	return ok
+}
+
 type nopCloser struct {
 	io.Writer
 }
@@ -92,7 +112,24 @@ func (a DirArchiver) Create(name string, size int64, mtime time.Time) (io.WriteC
 	if isdir {
 		return nil, os.Mkdir(p, 0770)
 	}
-	return os.OpenFile(p, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0660)
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0660)
+	if err != nil {
+		return nil, err
+	}
+	return dirFile{f, mtime}, nil
+}
+
+// dirFile sets the modification time of the file when closed.
+type dirFile struct {
+	*os.File
+	mtime time.Time
+}
+
+func (f dirFile) Close() error {
+	if err := f.File.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(f.Name(), f.mtime, f.mtime)
 }
 
 // Close on a dir does nothing.
@@ -122,16 +159,127 @@ func (a *MboxArchiver) Close() error {
 	return nil
 }
 
-// ExportMessages writes messages to archiver. Either in maildir format, or otherwise in
-// mbox. If mailboxOpt is empty, all mailboxes are exported, otherwise only the
-// named mailbox.
+// ExportFormat is the file format messages are exported in.
+type ExportFormat string
+
+const (
+	ExportMaildir ExportFormat = "maildir"
+	ExportMbox    ExportFormat = "mbox"
+
+	// Each message in its own .eml file, in a directory per mailbox. Flags, keywords
+	// and received times are stored in file EMLMetadataFile in each directory.
+	ExportEML ExportFormat = "eml"
+)
+
+// EMLMetadataFile is the name of the JSON file with EMLMetadata in each
+// directory of an EML export.
+const EMLMetadataFile = "metadata.json"
+
+// EMLMetadata holds the metadata for .eml files in a directory, for a mailbox.
+type EMLMetadata struct {
+	Messages []EMLMessage
+}
+
+// EMLMessage is the metadata for a single .eml file.
+type EMLMessage struct {
+	File     string    // Name of .eml file, without directory.
+	Received time.Time // Time message was received, also set as file modification time.
+	Flags    []string  // System and well-known flags like \seen and $junk, and keywords.
+}
+
+// ExportFilter selects the mailboxes and messages to export. The zero value
+// selects everything.
+type ExportFilter struct {
+	// Patterns for mailbox names, with syntax as for path.Match, e.g. "Archive/20*".
+	// A pattern matching a mailbox also matches its child mailboxes. If Include is
+	// non-empty, only mailboxes matching one of its patterns are exported. Mailboxes
+	// matching a pattern in Exclude are not exported.
+	Include []string
+	Exclude []string
+
+	// Only messages received at or after After, and before Before, if not zero.
+	After  time.Time
+	Before time.Time
+
+	// Only messages with all of Flags, and none of NotFlags. Flags are system flags
+	// like \Seen, well-known flags like $Junk, or keywords. Case-insensitive.
+	Flags    []string
+	NotFlags []string
+}
+
+// Check returns an error if a mailbox pattern is invalid.
+func (f ExportFilter) Check() error {
+	for _, p := range append(append([]string{}, f.Include...), f.Exclude...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("mailbox pattern %q: %w", p, err)
+		}
+	}
+	return nil
+}
+
+func (f ExportFilter) matchMailbox(name string) bool {
+	match := func(patterns []string) bool {
+		for _, p := range patterns {
+			// Check the name and its parents.
+			n := name
+			for {
+				if ok, _ := path.Match(p, n); ok {
+					return true
+				}
+				i := strings.LastIndex(n, "/")
+				if i < 0 {
+					break
+				}
+				n = n[:i]
+			}
+		}
+		return false
+	}
+	return (len(f.Include) == 0 || match(f.Include)) && !match(f.Exclude)
+}
+
+func (f ExportFilter) matchMessage(m Message) bool {
+	if !f.After.IsZero() && m.Received.Before(f.After) || !f.Before.IsZero() && !m.Received.Before(f.Before) {
+		return false
+	}
+	if len(f.Flags) == 0 && len(f.NotFlags) == 0 {
+		return true
+	}
+	have := map[string]bool{}
+	for _, s := range append(m.Flags.Strings(), m.Keywords...) {
+		have[s] = true
+	}
+	for _, s := range f.Flags {
+		if !have[strings.ToLower(s)] {
+			return false
+		}
+	}
+	for _, s := range f.NotFlags {
+		if have[strings.ToLower(s)] {
+			return false
+		}
+	}
+	return true
+}
+
+// ExportMessages writes messages to archiver, in maildir, mbox or eml format. If
+// mailboxOpt is empty, all mailboxes are exported, otherwise only the named
+// mailbox. Mailboxes and messages are further selected with filter.
+//
+// Files are written to the archiver as messages are read, without building
+// (parts of) the archive in memory or temporary files first. For a TarArchiver,
+// mbox files and maildir messages are read twice, first to determine their size.
 //
 // Some errors are not fatal and result in skipped messages. In that happens, a
 // file "errors.txt" is added to the archive describing the errors. The goal is to
 // let users export (hopefully) most messages even in the face of errors.
-func ExportMessages(ctx context.Context, log mlog.Log, db *bstore.DB, accountDir string, archiver Archiver, maildir bool, mailboxOpt string, recursive bool) error {
+func ExportMessages(ctx context.Context, log mlog.Log, db *bstore.DB, accountDir string, archiver Archiver, format ExportFormat, mailboxOpt string, recursive bool, filter ExportFilter) error {
 	// todo optimize: should prepare next file to add to archive (can be an mbox with many messages) while writing a file to the archive (which typically compresses, which takes time).
 
+	if err := filter.Check(); err != nil {
+		return err
+	}
+
 	// Start transaction without closure, we are going to close it early, but don't
 	// want to deal with declaring many variables now to be able to assign them in a
 	// closure and use them afterwards.
@@ -162,7 +310,7 @@ func ExportMessages(ctx context.Context, log mlog.Log, db *bstore.DB, accountDir
 	}
 	q := bstore.QueryTx[Mailbox](tx)
 	q.FilterFn(func(mb Mailbox) bool {
-		return mailboxOpt == "" || mb.Name == mailboxOpt || recursive && strings.HasPrefix(mb.Name, prefix)
+		return (mailboxOpt == "" || mb.Name == mailboxOpt || recursive && strings.HasPrefix(mb.Name, prefix)) && filter.matchMailbox(mb.Name)
 	})
 	q.SortAsc("Name")
 	err = q.ForEach(func(mb Mailbox) error {
@@ -170,7 +318,7 @@ func ExportMessages(ctx context.Context, log mlog.Log, db *bstore.DB, accountDir
 		if trimPrefix != "" {
 			mailboxName = strings.TrimPrefix(mailboxName, trimPrefix)
 		}
-		errmsgs, err := exportMailbox(log, tx, accountDir, mb.ID, mailboxName, archiver, maildir, start)
+		errmsgs, err := exportMailbox(log, tx, accountDir, mb.ID, mailboxName, archiver, format, filter, start)
 		if err != nil {
 			return err
 		}
@@ -200,16 +348,52 @@ func ExportMessages(ctx context.Context, log mlog.Log, db *bstore.DB, accountDir
 	return nil
 }
 
-func exportMailbox(log mlog.Log, tx *bstore.Tx, accountDir string, mailboxID int64, mailboxName string, archiver Archiver, maildir bool, start time.Time) (string, error) {
+func exportMailbox(log mlog.Log, tx *bstore.Tx, accountDir string, mailboxID int64, mailboxName string, archiver Archiver, format ExportFormat, filter ExportFilter, start time.Time) (string, error) {
 	var errors string
 
-	var mboxtmp *os.File
-	var mboxwriter *bufio.Writer
-	defer func() {
-		if mboxtmp != nil {
-			CloseRemoveTempFile(log, mboxtmp, "mbox")
+	// Tar needs the size of a file before its data. Instead of writing mbox files
+	// and converted maildir messages to temporary files or memory first, we make a
+	// first pass to count the bytes, and write the data in a second pass.
+	needSize := archiverNeedsSize(archiver)
+
+	// Query for the messages to export, in the same order for each pass.
+	query := func() *bstore.Query[Message] {
+		q := bstore.QueryTx[Message](tx)
+		q.FilterNonzero(Message{MailboxID: mailboxID})
+		q.FilterEqual("Expunged", false)
+		if !filter.After.IsZero() || !filter.Before.IsZero() || len(filter.Flags) > 0 || len(filter.NotFlags) > 0 {
+			q.FilterFn(filter.matchMessage)
+		}
+		q.SortAsc("Received", "ID")
+		return q
+	}
+
+	// openMessage returns a reader for a message, and its size. If the message file
+	// cannot be opened, a nil reader is returned and the problem is added to errors.
+	openMessage := func(m Message) (io.ReadCloser, int64) {
+		mp := filepath.Join(accountDir, "msg", MessagePath(m.ID))
+		if m.Size == int64(len(m.Sealed.MsgPrefix)) {
+			return io.NopCloser(bytes.NewReader(m.Sealed.MsgPrefix)), m.Size
 		}
-	}()
+		// Account directories are named after the account, needed for the key of
+		// encrypted message files.
+		key := messageKey(filepath.Base(accountDir))
+		fileSize, err := MessageFileSize(mp, key, m.FileEncrypted, m.FileCompressed)
+		if err != nil {
+			errors += fmt.Sprintf("open message file for id %d, path %s: %v (message skipped)\n", m.ID, mp, err)
+			return nil, 0
+		}
+		size := fileSize + int64(len(m.Sealed.MsgPrefix))
+		if size != m.Size {
+			errors += fmt.Sprintf("message size mismatch for message id %d, database has %d, size is %d+%d=%d, using calculated size\n", m.ID, m.Size, len(m.Sealed.MsgPrefix), fileSize, size)
+		}
+		return &MsgReader{prefix: m.Sealed.MsgPrefix, path: mp, key: key, encrypted: m.FileEncrypted, compressed: m.FileCompressed, size: size}, size
+	}
+
+	closeMessage := func(mr io.ReadCloser) {
+		err := mr.Close()
+		log.Check(err, "closing message file after export")
+	}
 
 	// For dovecot-keyword-style flags not in standard maildir.
 	maildirFlags := map[string]int{}
@@ -228,303 +412,363 @@ func exportMailbox(log mlog.Log, tx *bstore.Tx, accountDir string, mailboxID int
 		return string(rune('a' + i))
 	}
 
-	finishMailbox := func() error {
-		if maildir {
-			if len(maildirFlags) == 0 {
-				return nil
-			}
+	exportMaildirMessage := func(m Message) error {
+		p := mailboxName
+		if m.Flags.Seen {
+			p = path.Join(p, "cur")
+		} else {
+			p = path.Join(p, "new")
+		}
+		name := fmt.Sprintf("%d.%d.mox:2,", m.Received.Unix(), m.ID)
 
-			var b bytes.Buffer
-			for i, flag := range maildirFlaglist {
-				if _, err := fmt.Fprintf(&b, "%d %s\n", i, flag); err != nil {
-					return err
-				}
-			}
-			w, err := archiver.Create(mailboxName+"/dovecot-keywords", int64(b.Len()), start)
-			if err != nil {
-				return fmt.Errorf("adding dovecot-keywords: %v", err)
-			}
-			if _, err := w.Write(b.Bytes()); err != nil {
-				xerr := w.Close()
-				log.Check(xerr, "closing dovecot-keywords file after closing")
-				return fmt.Errorf("writing dovecot-keywords: %v", err)
-			}
-			maildirFlags = map[string]int{}
-			maildirFlaglist = nil
-			return w.Close()
+		// Standard flags. May need to be sorted.
+		if m.Flags.Draft {
+			name += "D"
+		}
+		if m.Flags.Flagged {
+			name += "F"
+		}
+		if m.Flags.Answered {
+			name += "R"
+		}
+		if m.Flags.Seen {
+			name += "S"
+		}
+		if m.Flags.Deleted {
+			name += "T"
 		}
 
-		if err := mboxwriter.Flush(); err != nil {
-			return fmt.Errorf("flush mbox writer: %v", err)
+		// Non-standard flag. We set them with a dovecot-keywords file.
+		if m.Flags.Forwarded {
+			name += maildirFlag("$Forwarded")
 		}
-		fi, err := mboxtmp.Stat()
-		if err != nil {
-			return fmt.Errorf("stat temporary mbox file: %v", err)
+		if m.Flags.Junk {
+			name += maildirFlag("$Junk")
 		}
-		if _, err := mboxtmp.Seek(0, 0); err != nil {
-			return fmt.Errorf("seek to start of temporary mbox file")
+		if m.Flags.Notjunk {
+			name += maildirFlag("$NotJunk")
 		}
-		w, err := archiver.Create(mailboxName+".mbox", fi.Size(), fi.ModTime())
-		if err != nil {
-			return fmt.Errorf("add mbox to archive: %v", err)
+		if m.Flags.Phishing {
+			name += maildirFlag("$Phishing")
 		}
-		if _, err := io.Copy(w, mboxtmp); err != nil {
-			xerr := w.Close()
-			log.Check(xerr, "closing mbox message file after error")
-			return fmt.Errorf("copying temp mbox file to archive: %v", err)
+		if m.Flags.MDNSent {
+			name += maildirFlag("$MDNSent")
 		}
-		if err := w.Close(); err != nil {
-			return fmt.Errorf("closing message file: %v", err)
-		}
-		name := mboxtmp.Name()
-		err = mboxtmp.Close()
-		log.Check(err, "closing temporary mbox file")
-		err = os.Remove(name)
-		log.Check(err, "removing temporary mbox file", slog.String("path", name))
-		mboxwriter = nil
-		mboxtmp = nil
-		return nil
-	}
 
-	exportMessage := func(m Message) error {
-		mp := filepath.Join(accountDir, "msg", MessagePath(m.ID))
-		var mr io.ReadCloser
-		if m.Size == int64(len(m.MsgPrefix)) {
-			mr = io.NopCloser(bytes.NewReader(m.MsgPrefix))
-		} else {
-			mf, err := os.Open(mp)
-			if err != nil {
-				errors += fmt.Sprintf("open message file for id %d, path %s: %v (message skipped)\n", m.ID, mp, err)
+		p = path.Join(p, name)
+
+		// We store messages with \r\n, maildir needs without. The size after conversion
+		// is only known after reading the message.
+		var size int64
+		if needSize {
+			prevErrors := errors
+			mr, _ := openMessage(m)
+			if mr == nil {
 				return nil
 			}
-			defer func() {
-				err := mf.Close()
-				log.Check(err, "closing message file after export")
-			}()
-			st, err := mf.Stat()
+			// Problems are added again when opening for writing.
+			errors = prevErrors
+			var cw countWriter
+			err := writeMaildirMessage(&cw, mr)
+			closeMessage(mr)
 			if err != nil {
-				errors += fmt.Sprintf("stat message file for id %d, path %s: %v (message skipped)\n", m.ID, mp, err)
+				errors += fmt.Sprintf("reading from message for id %d: %v (message skipped)\n", m.ID, err)
 				return nil
 			}
-			size := st.Size() + int64(len(m.MsgPrefix))
-			if size != m.Size {
-				errors += fmt.Sprintf("message size mismatch for message id %d, database has %d, size is %d+%d=%d, using calculated size\n", m.ID, m.Size, len(m.MsgPrefix), st.Size(), size)
-			}
-			mr = FileMsgReader(m.MsgPrefix, mf)
+			size = cw.n
 		}
 
-		if maildir {
-			p := mailboxName
-			if m.Flags.Seen {
-				p = filepath.Join(p, "cur")
-			} else {
-				p = filepath.Join(p, "new")
-			}
-			name := fmt.Sprintf("%d.%d.mox:2,", m.Received.Unix(), m.ID)
-
-			// Standard flags. May need to be sorted.
-			if m.Flags.Draft {
-				name += "D"
-			}
-			if m.Flags.Flagged {
-				name += "F"
-			}
-			if m.Flags.Answered {
-				name += "R"
-			}
-			if m.Flags.Seen {
-				name += "S"
-			}
-			if m.Flags.Deleted {
-				name += "T"
-			}
+		mr, _ := openMessage(m)
+		if mr == nil {
+			return nil
+		}
+		defer closeMessage(mr)
+		w, err := archiver.Create(p, size, m.Received)
+		if err != nil {
+			return fmt.Errorf("adding message to archive: %v", err)
+		}
+		if err := writeMaildirMessage(w, mr); err != nil {
+			xerr := w.Close()
+			log.Check(xerr, "closing message")
+			return fmt.Errorf("copying message to archive: %v", err)
+		}
+		return w.Close()
+	}
 
-			// Non-standard flag. We set them with a dovecot-keywords file.
-			if m.Flags.Forwarded {
-				name += maildirFlag("$Forwarded")
-			}
-			if m.Flags.Junk {
-				name += maildirFlag("$Junk")
-			}
-			if m.Flags.Notjunk {
-				name += maildirFlag("$NotJunk")
-			}
-			if m.Flags.Phishing {
-				name += maildirFlag("$Phishing")
-			}
-			if m.Flags.MDNSent {
-				name += maildirFlag("$MDNSent")
-			}
+	exportEMLMessage := func(m Message) error {
+		mr, size := openMessage(m)
+		if mr == nil {
+			return nil
+		}
+		defer closeMessage(mr)
 
-			p = filepath.Join(p, name)
+		// Messages are stored with \r\n, as is common for .eml files.
+		w, err := archiver.Create(mailboxName+"/"+emlName(m), size, m.Received)
+		if err != nil {
+			return fmt.Errorf("adding message to archive: %v", err)
+		}
+		if _, err := io.Copy(w, mr); err != nil {
+			xerr := w.Close()
+			log.Check(xerr, "closing message")
+			return fmt.Errorf("copying message to archive: %v", err)
+		}
+		return w.Close()
+	}
 
-			// We store messages with \r\n, maildir needs without. But we need to know the
-			// final size. So first convert, then create file with size, and write from buffer.
-			// todo: for large messages, we should go through a temporary file instead of memory.
-			var dst bytes.Buffer
-			r := bufio.NewReader(mr)
-			for {
-				line, rerr := r.ReadBytes('\n')
-				if rerr != io.EOF && rerr != nil {
-					errors += fmt.Sprintf("reading from message for id %d: %v (message skipped)\n", m.ID, rerr)
-					return nil
-				}
-				if len(line) > 0 {
-					if bytes.HasSuffix(line, []byte("\r\n")) {
-						line = line[:len(line)-1]
-						line[len(line)-1] = '\n'
-					}
-					if _, err := dst.Write(line); err != nil {
-						return fmt.Errorf("writing message: %v", err)
-					}
-				}
-				if rerr == io.EOF {
-					break
-				}
-			}
-			size := int64(dst.Len())
-			w, err := archiver.Create(p, size, m.Received)
-			if err != nil {
-				return fmt.Errorf("adding message to archive: %v", err)
-			}
-			if _, err := io.Copy(w, &dst); err != nil {
-				xerr := w.Close()
-				log.Check(xerr, "closing message")
-				return fmt.Errorf("copying message to archive: %v", err)
+	// writeMbox writes all messages in mbox format to w.
+	writeMbox := func(w io.Writer) error {
+		return query().ForEach(func(m Message) error {
+			mr, _ := openMessage(m)
+			if mr == nil {
+				return nil
 			}
-			return w.Close()
+			defer closeMessage(mr)
+			return writeMboxMessage(w, m, mr)
+		})
+	}
+
+	switch format {
+	case ExportMaildir:
+		// Create the directories that show this is a maildir.
+		if _, err := archiver.Create(mailboxName+"/new/", 0, start); err != nil {
+			return errors, fmt.Errorf("adding maildir new directory: %v", err)
+		}
+		if _, err := archiver.Create(mailboxName+"/cur/", 0, start); err != nil {
+			return errors, fmt.Errorf("adding maildir cur directory: %v", err)
+		}
+		if _, err := archiver.Create(mailboxName+"/tmp/", 0, start); err != nil {
+			return errors, fmt.Errorf("adding maildir tmp directory: %v", err)
 		}
 
-		mailfrom := "mox"
-		if m.MailFrom != "" {
-			mailfrom = m.MailFrom
+		if err := query().ForEach(exportMaildirMessage); err != nil {
+			return errors, err
 		}
-		if _, err := fmt.Fprintf(mboxwriter, "From %s %s\n", mailfrom, m.Received.Format(time.ANSIC)); err != nil {
-			return fmt.Errorf("write message line to mbox temp file: %v", err)
+
+		if len(maildirFlags) == 0 {
+			return errors, nil
 		}
 
-		// Write message flags in the three headers that mbox consumers may (or may not) understand.
-		if m.Seen {
-			if _, err := fmt.Fprintf(mboxwriter, "Status: R\n"); err != nil {
-				return fmt.Errorf("writing status header: %v", err)
+		var b bytes.Buffer
+		for i, flag := range maildirFlaglist {
+			if _, err := fmt.Fprintf(&b, "%d %s\n", i, flag); err != nil {
+				return errors, err
 			}
 		}
-		xstatus := ""
-		if m.Answered {
-			xstatus += "A"
+		w, err := archiver.Create(mailboxName+"/dovecot-keywords", int64(b.Len()), start)
+		if err != nil {
+			return errors, fmt.Errorf("adding dovecot-keywords: %v", err)
 		}
-		if m.Flagged {
-			xstatus += "F"
+		if _, err := w.Write(b.Bytes()); err != nil {
+			xerr := w.Close()
+			log.Check(xerr, "closing dovecot-keywords file after closing")
+			return errors, fmt.Errorf("writing dovecot-keywords: %v", err)
 		}
-		if m.Draft {
-			xstatus += "T"
+		return errors, w.Close()
+
+	case ExportEML:
+		if _, err := archiver.Create(mailboxName+"/", 0, start); err != nil {
+			return errors, fmt.Errorf("adding mailbox directory: %v", err)
 		}
-		if m.Deleted {
-			xstatus += "D"
+
+		// Metadata is written before the messages, so importers reading an archive
+		// sequentially know the flags when they get to the messages.
+		var md EMLMetadata
+		err := query().ForEach(func(m Message) error {
+			flags := append(m.Flags.Strings(), m.Keywords...)
+			md.Messages = append(md.Messages, EMLMessage{emlName(m), m.Received, flags})
+			return nil
+		})
+		if err != nil {
+			return errors, fmt.Errorf("listing messages for eml metadata: %v", err)
 		}
-		if xstatus != "" {
-			if _, err := fmt.Fprintf(mboxwriter, "X-Status: %s\n", xstatus); err != nil {
-				return fmt.Errorf("writing x-status header: %v", err)
-			}
+		buf, err := json.MarshalIndent(md, "", "\t")
+		if err != nil {
+			return errors, fmt.Errorf("marshal eml metadata: %v", err)
 		}
-		var xkeywords []string
-		if m.Forwarded {
-			xkeywords = append(xkeywords, "$Forwarded")
+		w, err := archiver.Create(mailboxName+"/"+EMLMetadataFile, int64(len(buf)), start)
+		if err != nil {
+			return errors, fmt.Errorf("adding eml metadata: %v", err)
 		}
-		if m.Junk && !m.Notjunk {
-			xkeywords = append(xkeywords, "$Junk")
+		if _, err := w.Write(buf); err != nil {
+			xerr := w.Close()
+			log.Check(xerr, "closing eml metadata file after error")
+			return errors, fmt.Errorf("writing eml metadata: %v", err)
 		}
-		if m.Notjunk && !m.Junk {
-			xkeywords = append(xkeywords, "$NotJunk")
+		if err := w.Close(); err != nil {
+			return errors, fmt.Errorf("closing eml metadata: %v", err)
+		}
+
+		return errors, query().ForEach(exportEMLMessage)
+
+	default:
+		var size int64
+		if needSize {
+			var cw countWriter
+			if err := writeMbox(&cw); err != nil {
+				return errors, fmt.Errorf("determining size of mbox: %v", err)
+			}
+			size = cw.n
+			// Problems are added again in the second pass.
+			errors = ""
+		}
+
+		w, err := archiver.Create(mailboxName+".mbox", size, start)
+		if err != nil {
+			return errors, fmt.Errorf("add mbox to archive: %v", err)
 		}
-		if m.Phishing {
-			xkeywords = append(xkeywords, "$Phishing")
+		bw := bufio.NewWriter(w)
+		err = writeMbox(bw)
+		if err == nil {
+			err = bw.Flush()
 		}
-		if m.MDNSent {
-			xkeywords = append(xkeywords, "$MDNSent")
+		if err != nil {
+			xerr := w.Close()
+			log.Check(xerr, "closing mbox file after error")
+			return errors, fmt.Errorf("writing mbox to archive: %v", err)
 		}
-		if len(xkeywords) > 0 {
-			if _, err := fmt.Fprintf(mboxwriter, "X-Keywords: %s\n", strings.Join(xkeywords, ",")); err != nil {
-				return fmt.Errorf("writing x-keywords header: %v", err)
-			}
+		if err := w.Close(); err != nil {
+			return errors, fmt.Errorf("closing mbox file: %v", err)
 		}
+		return errors, nil
+	}
+}
 
-		header := true
-		r := bufio.NewReader(mr)
-		for {
-			line, rerr := r.ReadBytes('\n')
-			if rerr != io.EOF && rerr != nil {
-				return fmt.Errorf("reading message: %v", rerr)
-			}
-			if len(line) > 0 {
-				if bytes.HasSuffix(line, []byte("\r\n")) {
-					line = line[:len(line)-1]
-					line[len(line)-1] = '\n'
-				}
-				if header && len(line) == 1 {
-					header = false
-				}
-				if header {
-					// Skip any previously stored flag-holding or now incorrect content-length headers.
-					// This assumes these headers are just a single line.
-					switch strings.ToLower(string(bytes.SplitN(line, []byte(":"), 2)[0])) {
-					case "status", "x-status", "x-keywords", "content-length":
-						continue
-					}
-				}
-				if bytes.HasPrefix(bytes.TrimLeft(line, ">"), []byte("From ")) {
-					if _, err := fmt.Fprint(mboxwriter, ">"); err != nil {
-						return fmt.Errorf("writing escaping >: %v", err)
-					}
-				}
-				if _, err := mboxwriter.Write(line); err != nil {
-					return fmt.Errorf("writing line: %v", err)
-				}
+// countWriter counts the bytes written, for determining the size of files
+// without storing their data.
+type countWriter struct {
+	n int64
+}
+
+func (w *countWriter) Write(buf []byte) (int, error) {
+	w.n += int64(len(buf))
+	return len(buf), nil
+}
+
+// writeMaildirMessage copies a message from r to w, converting \r\n into \n.
+func writeMaildirMessage(w io.Writer, r io.Reader) error {
+	br := bufio.NewReader(r)
+	bw := bufio.NewWriter(w)
+	for {
+		line, rerr := br.ReadBytes('\n')
+		if rerr != io.EOF && rerr != nil {
+			return rerr
+		}
+		if len(line) > 0 {
+			if bytes.HasSuffix(line, []byte("\r\n")) {
+				line = line[:len(line)-1]
+				line[len(line)-1] = '\n'
 			}
-			if rerr == io.EOF {
-				break
+			if _, err := bw.Write(line); err != nil {
+				return err
 			}
 		}
-		if _, err := fmt.Fprint(mboxwriter, "\n"); err != nil {
-			return fmt.Errorf("writing end of message newline: %v", err)
+		if rerr == io.EOF {
+			break
 		}
-		return nil
 	}
+	return bw.Flush()
+}
 
-	if maildir {
-		// Create the directories that show this is a maildir.
-		if _, err := archiver.Create(mailboxName+"/new/", 0, start); err != nil {
-			return errors, fmt.Errorf("adding maildir new directory: %v", err)
-		}
-		if _, err := archiver.Create(mailboxName+"/cur/", 0, start); err != nil {
-			return errors, fmt.Errorf("adding maildir cur directory: %v", err)
+// writeMboxMessage writes message m read from r to w, in mboxrd format, with
+// flags in Status, X-Status and X-Keywords headers.
+func writeMboxMessage(w io.Writer, m Message, r io.Reader) error {
+	mailfrom := "mox"
+	if m.Sealed.MailFrom != "" {
+		mailfrom = m.Sealed.MailFrom
+	}
+	if _, err := fmt.Fprintf(w, "From %s %s\n", mailfrom, m.Received.Format(time.ANSIC)); err != nil {
+		return fmt.Errorf("write message line to mbox: %v", err)
+	}
+
+	// Write message flags in the three headers that mbox consumers may (or may not) understand.
+	if m.Seen {
+		if _, err := fmt.Fprintf(w, "Status: R\n"); err != nil {
+			return fmt.Errorf("writing status header: %v", err)
 		}
-		if _, err := archiver.Create(mailboxName+"/tmp/", 0, start); err != nil {
-			return errors, fmt.Errorf("adding maildir tmp directory: %v", err)
+	}
+	xstatus := ""
+	if m.Answered {
+		xstatus += "A"
+	}
+	if m.Flagged {
+		xstatus += "F"
+	}
+	if m.Draft {
+		xstatus += "T"
+	}
+	if m.Deleted {
+		xstatus += "D"
+	}
+	if xstatus != "" {
+		if _, err := fmt.Fprintf(w, "X-Status: %s\n", xstatus); err != nil {
+			return fmt.Errorf("writing x-status header: %v", err)
 		}
-	} else {
-		var err error
-		mboxtmp, err = os.CreateTemp("", "mox-mail-export-mbox")
-		if err != nil {
-			return errors, fmt.Errorf("creating temp mbox file: %v", err)
+	}
+	var xkeywords []string
+	if m.Forwarded {
+		xkeywords = append(xkeywords, "$Forwarded")
+	}
+	if m.Junk && !m.Notjunk {
+		xkeywords = append(xkeywords, "$Junk")
+	}
+	if m.Notjunk && !m.Junk {
+		xkeywords = append(xkeywords, "$NotJunk")
+	}
+	if m.Phishing {
+		xkeywords = append(xkeywords, "$Phishing")
+	}
+	if m.MDNSent {
+		xkeywords = append(xkeywords, "$MDNSent")
+	}
+	if len(xkeywords) > 0 {
+		if _, err := fmt.Fprintf(w, "X-Keywords: %s\n", strings.Join(xkeywords, ",")); err != nil {
+			return fmt.Errorf("writing x-keywords header: %v", err)
 		}
-		mboxwriter = bufio.NewWriter(mboxtmp)
 	}
 
-	// Fetch all messages for mailbox.
-	q := bstore.QueryTx[Message](tx)
-	q.FilterNonzero(Message{MailboxID: mailboxID})
-	q.FilterEqual("Expunged", false)
-	q.SortAsc("Received", "ID")
-	err := q.ForEach(func(m Message) error {
-		return exportMessage(m)
-	})
-	if err != nil {
-		return errors, err
+	header := true
+	br := bufio.NewReader(r)
+	for {
+		line, rerr := br.ReadBytes('\n')
+		if rerr != io.EOF && rerr != nil {
+			return fmt.Errorf("reading message: %v", rerr)
+		}
+		if len(line) > 0 {
+			if bytes.HasSuffix(line, []byte("\r\n")) {
+				line = line[:len(line)-1]
+				line[len(line)-1] = '\n'
+			}
+			if header && len(line) == 1 {
+				header = false
+			}
+			if header {
+				// Skip any previously stored flag-holding or now incorrect content-length headers.
+				// This assumes these headers are just a single line.
+				switch strings.ToLower(string(bytes.SplitN(line, []byte(":"), 2)[0])) {
+				case "status", "x-status", "x-keywords", "content-length":
+					continue
+				}
+			}
+			if bytes.HasPrefix(bytes.TrimLeft(line, ">"), []byte("From ")) {
+				if _, err := fmt.Fprint(w, ">"); err != nil {
+					return fmt.Errorf("writing escaping >: %v", err)
+				}
+			}
+			if _, err := w.Write(line); err != nil {
+				return fmt.Errorf("writing line: %v", err)
+			}
+		}
+		if rerr == io.EOF {
+			break
+		}
 	}
-	if err := finishMailbox(); err != nil {
-		return errors, err
+	if _, err := fmt.Fprint(w, "\n"); err != nil {
+		return fmt.Errorf("writing end of message newline: %v", err)
 	}
+	return nil
+}
 
-	return errors, nil
+// emlName returns the file name for a message in an eml export.
+func emlName(m Message) string {
+	return fmt.Sprintf("%d.%d.eml", m.Received.Unix(), m.ID)
 }
@@ -8,6 +8,7 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -41,7 +42,8 @@ func TestExport(t *testing.T) {
 	_, err = msgFile.Write([]byte(msg))
 	tcheck(t, err, "write message")
 
-	m := Message{Received: time.Now(), Size: int64(len(msg))}
+	received := time.Now().Add(-time.Hour).Round(time.Second)
+	m := Message{Received: received, Size: int64(len(msg)), Flags: Flags{Seen: true}, Keywords: []string{"custom"}}
 	err = acc.DeliverMailbox(pkglog, "Inbox", &m, msgFile)
 	tcheck(t, err, "deliver")
 
@@ -49,11 +51,11 @@ func TestExport(t *testing.T) {
 	err = acc.DeliverMailbox(pkglog, "Trash", &m, msgFile)
 	tcheck(t, err, "deliver")
 
-	var maildirZip, maildirTar, mboxZip, mboxTar bytes.Buffer
+	var maildirZip, maildirTar, mboxZip, mboxTar, emlZip bytes.Buffer
 
-	archive := func(archiver Archiver, maildir bool) {
+	archive := func(archiver Archiver, format ExportFormat) {
 		t.Helper()
-		err = ExportMessages(ctxbg, log, acc.DB, acc.Dir, archiver, maildir, "", true)
+		err = ExportMessages(ctxbg, log, acc.DB, acc.Dir, archiver, format, "", true, ExportFilter{})
 		tcheck(t, err, "export messages")
 		err = archiver.Close()
 		tcheck(t, err, "archiver close")
@@ -61,13 +63,16 @@ func TestExport(t *testing.T) {
 
 	os.RemoveAll("../testdata/exportmaildir")
 	os.RemoveAll("../testdata/exportmbox")
+	os.RemoveAll("../testdata/exporteml")
 
-	archive(ZipArchiver{zip.NewWriter(&maildirZip)}, true)
-	archive(ZipArchiver{zip.NewWriter(&mboxZip)}, false)
-	archive(TarArchiver{tar.NewWriter(&maildirTar)}, true)
-	archive(TarArchiver{tar.NewWriter(&mboxTar)}, false)
-	archive(DirArchiver{filepath.FromSlash("../testdata/exportmaildir")}, true)
-	archive(DirArchiver{filepath.FromSlash("../testdata/exportmbox")}, false)
+	archive(ZipArchiver{zip.NewWriter(&maildirZip)}, ExportMaildir)
+	archive(ZipArchiver{zip.NewWriter(&mboxZip)}, ExportMbox)
+	archive(ZipArchiver{zip.NewWriter(&emlZip)}, ExportEML)
+	archive(TarArchiver{tar.NewWriter(&maildirTar)}, ExportMaildir)
+	archive(TarArchiver{tar.NewWriter(&mboxTar)}, ExportMbox)
+	archive(DirArchiver{filepath.FromSlash("../testdata/exportmaildir")}, ExportMaildir)
+	archive(DirArchiver{filepath.FromSlash("../testdata/exportmbox")}, ExportMbox)
+	archive(DirArchiver{filepath.FromSlash("../testdata/exporteml")}, ExportEML)
 
 	const defaultMailboxes = 6 // Inbox, Drafts, etc
 	if r, err := zip.NewReader(bytes.NewReader(maildirZip.Bytes()), int64(maildirZip.Len())); err != nil {
@@ -82,6 +87,12 @@ func TestExport(t *testing.T) {
 		t.Fatalf("maildir zip, expected %d files, got %d files", defaultMailboxes, len(r.File))
 	}
 
+	if r, err := zip.NewReader(bytes.NewReader(emlZip.Bytes()), int64(emlZip.Len())); err != nil {
+		t.Fatalf("reading eml zip: %v", err)
+	} else if len(r.File) != defaultMailboxes*2+2 {
+		t.Fatalf("eml zip, expected %d dirs and metadata files, and 2 files, got %d files", defaultMailboxes, len(r.File))
+	}
+
 	checkTarFiles := func(r io.Reader, n int) {
 		t.Helper()
 		tr := tar.NewReader(r)
@@ -123,4 +134,54 @@ func TestExport(t *testing.T) {
 
 	checkDirFiles(filepath.FromSlash("../testdata/exportmaildir"), 2)
 	checkDirFiles(filepath.FromSlash("../testdata/exportmbox"), defaultMailboxes)
+	checkDirFiles(filepath.FromSlash("../testdata/exporteml"), defaultMailboxes+2)
+
+	// Read the eml export of the inbox back, flags and received time must be preserved.
+	mr, err := NewEMLReader(log, CreateMessageTemp, filepath.FromSlash("../testdata/exporteml/Inbox"))
+	tcheck(t, err, "eml reader")
+	rm, rf, _, err := mr.Next()
+	tcheck(t, err, "next eml message")
+	CloseRemoveTempFile(log, rf, "eml message")
+	tcompare(t, rm.Received.Equal(received), true)
+	tcompare(t, rm.Flags, Flags{Seen: true})
+	tcompare(t, rm.Keywords, []string{"custom"})
+	tcompare(t, rm.Size, int64(len(msg)))
+	_, _, _, err = mr.Next()
+	tcompare(t, err, io.EOF)
+
+	// Filters. We count the mailbox directories and messages in an eml export.
+	exportFiltered := func(filter ExportFilter, expMailboxes, expMessages int) {
+		t.Helper()
+		var buf bytes.Buffer
+		err := ExportMessages(ctxbg, log, acc.DB, acc.Dir, TarArchiver{tar.NewWriter(&buf)}, ExportEML, "", true, filter)
+		tcheck(t, err, "export messages")
+		var mailboxes, messages int
+		tr := tar.NewReader(&buf)
+		for {
+			h, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			tcheck(t, err, "next tar file")
+			if strings.HasSuffix(h.Name, "/") {
+				mailboxes++
+			} else if strings.HasSuffix(h.Name, ".eml") {
+				messages++
+			}
+		}
+		tcompare(t, mailboxes, expMailboxes)
+		tcompare(t, messages, expMessages)
+	}
+	exportFiltered(ExportFilter{Include: []string{"Inbox"}}, 1, 1)
+	exportFiltered(ExportFilter{Exclude: []string{"Inbox", "T*"}}, defaultMailboxes-2, 0)
+	exportFiltered(ExportFilter{Flags: []string{`\Seen`}}, defaultMailboxes, 1)
+	exportFiltered(ExportFilter{NotFlags: []string{"custom"}}, defaultMailboxes, 1)
+	exportFiltered(ExportFilter{Before: received.Add(time.Second)}, defaultMailboxes, 1)
+	exportFiltered(ExportFilter{After: received.Add(time.Second)}, defaultMailboxes, 1)
+	exportFiltered(ExportFilter{After: received.Add(time.Second), Before: received.Add(2 * time.Second)}, defaultMailboxes, 0)
+
+	err = ExportMessages(ctxbg, log, acc.DB, acc.Dir, TarArchiver{tar.NewWriter(io.Discard)}, ExportEML, "", true, ExportFilter{Include: []string{"["}})
+	if err == nil {
+		t.Fatalf("export with bad pattern succeeded")
+	}
 }
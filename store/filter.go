@@ -0,0 +1,258 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/textproto"
+	"os"
+	"strings"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/smtp"
+)
+
+// ErrFilterRuleParam is returned when saving a filter rule with invalid
+// parameters.
+var ErrFilterRuleParam = errors.New("invalid filter rule parameter")
+
+// FilterRule is a filtering rule for incoming messages of an account, managed by
+// the user in webmail. Rules are evaluated in order of Position during delivery,
+// after the rulesets from the configuration file. The first matching rule is
+// applied. All non-empty conditions must match. Conditions are case-insensitive
+// substring matches.
+type FilterRule struct {
+	ID       int64
+	Position int // Order of evaluation, lower first.
+	Name     string
+	Disabled bool
+
+	// Conditions.
+	From        string // Name or address in From header.
+	To          string // Name or address in To or Cc header.
+	Subject     string
+	HeaderName  string // Header to match, with HeaderValue. E.g. "List-Id".
+	HeaderValue string // If empty, only the presence of HeaderName is required.
+	SizeMin     int64  // Minimum message size in bytes, if > 0.
+	SizeMax     int64  // Maximum message size in bytes, if > 0.
+
+	// Actions.
+	Mailbox   string   // Deliver to this mailbox instead of the default.
+	Seen      bool     // Mark message as read.
+	Flagged   bool     // Mark message as flagged.
+	Keywords  []string // Keywords to add to message.
+	ForwardTo string   // Send a copy of the message to this address.
+	Discard   bool     // Do not store the message.
+}
+
+// check validates and normalizes the rule.
+func (r *FilterRule) check() error {
+	r.Name = strings.TrimSpace(r.Name)
+	r.HeaderName = strings.TrimSpace(r.HeaderName)
+	r.ForwardTo = strings.TrimSpace(r.ForwardTo)
+	r.Mailbox = strings.TrimSpace(r.Mailbox)
+
+	if r.From == "" && r.To == "" && r.Subject == "" && r.HeaderName == "" && r.SizeMin <= 0 && r.SizeMax <= 0 {
+		return fmt.Errorf("%w: rule must have at least one condition", ErrFilterRuleParam)
+	}
+	if r.HeaderValue != "" && r.HeaderName == "" {
+		return fmt.Errorf("%w: header value requires header name", ErrFilterRuleParam)
+	}
+	if r.SizeMin < 0 || r.SizeMax < 0 || r.SizeMax > 0 && r.SizeMin > r.SizeMax {
+		return fmt.Errorf("%w: invalid size range", ErrFilterRuleParam)
+	}
+
+	if r.Mailbox == "" && !r.Seen && !r.Flagged && len(r.Keywords) == 0 && r.ForwardTo == "" && !r.Discard {
+		return fmt.Errorf("%w: rule must have at least one action", ErrFilterRuleParam)
+	}
+	if r.Discard && (r.Mailbox != "" || r.Seen || r.Flagged || len(r.Keywords) > 0) {
+		return fmt.Errorf("%w: discard cannot be combined with mailbox or marking", ErrFilterRuleParam)
+	}
+	if r.Mailbox != "" {
+		name, _, err := CheckMailboxName(r.Mailbox, true)
+		if err != nil {
+			return fmt.Errorf("%w: mailbox: %v", ErrFilterRuleParam, err)
+		}
+		r.Mailbox = name
+	}
+	if len(r.Keywords) > 0 {
+		flags, keywords, err := ParseFlagsKeywords(r.Keywords)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrFilterRuleParam, err)
+		} else if flags != (Flags{}) {
+			return fmt.Errorf("%w: keywords cannot contain system flags", ErrFilterRuleParam)
+		}
+		r.Keywords = keywords
+	} else {
+		r.Keywords = nil
+	}
+	if r.ForwardTo != "" {
+		addr, err := smtp.ParseAddress(r.ForwardTo)
+		if err != nil {
+			return fmt.Errorf("%w: forward address: %v", ErrFilterRuleParam, err)
+		}
+		r.ForwardTo = addr.String()
+	}
+	return nil
+}
+
+// Match returns whether the rule matches the message, given its parsed part and
+// size.
+func (r FilterRule) Match(log mlog.Log, p *message.Part, size int64) bool {
+	if r.Disabled {
+		return false
+	}
+	if r.SizeMin > 0 && size < r.SizeMin || r.SizeMax > 0 && size > r.SizeMax {
+		return false
+	}
+
+	contains := func(s, sub string) bool {
+		return strings.Contains(strings.ToLower(s), strings.ToLower(sub))
+	}
+	addrsContain := func(l []message.Address, sub string) bool {
+		for _, a := range l {
+			if contains(a.Name+" <"+a.User+"@"+a.Host+">", sub) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var env message.Envelope
+	if p.Envelope != nil {
+		env = *p.Envelope
+	}
+	if r.From != "" && !addrsContain(env.From, r.From) {
+		return false
+	}
+	if r.To != "" && !addrsContain(env.To, r.To) && !addrsContain(env.CC, r.To) {
+		return false
+	}
+	if r.Subject != "" && !contains(env.Subject, r.Subject) {
+		return false
+	}
+	if r.HeaderName != "" {
+		h, err := p.Header()
+		if err != nil {
+			log.Debugx("parsing message header for filter rule", err)
+			return false
+		}
+		vl := h.Values(textproto.CanonicalMIMEHeaderKey(r.HeaderName))
+		if len(vl) == 0 {
+			return false
+		}
+		if r.HeaderValue != "" {
+			var dec mime.WordDecoder
+			var found bool
+			for _, v := range vl {
+				if s, err := dec.DecodeHeader(v); err == nil {
+					v = s
+				}
+				if contains(v, r.HeaderValue) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// FilterRules returns the filter rules of the account, in order of evaluation.
+func (a *Account) FilterRules(ctx context.Context) ([]FilterRule, error) {
+	return bstore.QueryDB[FilterRule](ctx, a.DB).SortAsc("Position", "ID").List()
+}
+
+// FilterRuleSave validates and stores a filter rule. If r.ID is 0, a new rule is
+// added after the existing rules, otherwise the existing rule is updated, keeping
+// its position.
+func (a *Account) FilterRuleSave(ctx context.Context, r FilterRule) (FilterRule, error) {
+	if err := r.check(); err != nil {
+		return FilterRule{}, err
+	}
+	err := a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		if r.ID == 0 {
+			last, err := bstore.QueryTx[FilterRule](tx).SortDesc("Position").Limit(1).Get()
+			if err == nil {
+				r.Position = last.Position + 1
+			} else if err != bstore.ErrAbsent {
+				return err
+			}
+			return tx.Insert(&r)
+		}
+		or := FilterRule{ID: r.ID}
+		if err := tx.Get(&or); err != nil {
+			return err
+		}
+		r.Position = or.Position
+		return tx.Update(&r)
+	})
+	return r, err
+}
+
+// FilterRuleRemove removes a filter rule.
+func (a *Account) FilterRuleRemove(ctx context.Context, id int64) error {
+	return a.DB.Delete(ctx, &FilterRule{ID: id})
+}
+
+// FilterRulesReorder sets the order of evaluation of the filter rules. All rules
+// must be present in ids.
+func (a *Account) FilterRulesReorder(ctx context.Context, ids []int64) error {
+	return a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		n, err := bstore.QueryTx[FilterRule](tx).Count()
+		if err != nil {
+			return err
+		}
+		if n != len(ids) {
+			return fmt.Errorf("%w: must specify all %d rules", ErrFilterRuleParam, n)
+		}
+		seen := map[int64]bool{}
+		for i, id := range ids {
+			if seen[id] {
+				return fmt.Errorf("%w: duplicate rule id %d", ErrFilterRuleParam, id)
+			}
+			seen[id] = true
+			r := FilterRule{ID: id}
+			if err := tx.Get(&r); err != nil {
+				return fmt.Errorf("get rule %d: %w", id, err)
+			}
+			r.Position = i
+			if err := tx.Update(&r); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// FilterRuleMatch returns the first enabled filter rule that matches the incoming
+// message m with msgFile, or nil if none matches.
+func (a *Account) FilterRuleMatch(log mlog.Log, m *Message, msgFile *os.File) *FilterRule {
+	rules, err := bstore.QueryDB[FilterRule](context.TODO(), a.DB).FilterEqual("Disabled", false).SortAsc("Position", "ID").List()
+	if err != nil {
+		log.Errorx("listing filter rules, not filtering", err)
+		return nil
+	} else if len(rules) == 0 {
+		return nil
+	}
+
+	p, err := m.EnsureParsed(log, msgFile)
+	if err != nil {
+		log.Errorx("parsing message for evaluating filter rules, not filtering", err, slog.String("parse", ""))
+		return nil
+	}
+	for _, r := range rules {
+		if r.Match(log, &p, m.Size) {
+			return &r
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+)
+
+func TestFilterRules(t *testing.T) {
+	log := mlog.New("store", nil)
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	acc, err := OpenAccount(log, "mjl")
+	tcheck(t, err, "open account")
+	defer func() {
+		err = acc.Close()
+		tcheck(t, err, "closing account")
+		acc.CheckClosed()
+	}()
+	defer Switchboard()()
+
+	// Invalid rules.
+	bad := []FilterRule{
+		{Mailbox: "Lists"}, // No condition.
+		{Subject: "x"},     // No action.
+		{Subject: "x", Discard: true, Seen: true},           // Discard with marking.
+		{Subject: "x", ForwardTo: "bogus"},                  // Bad address.
+		{Subject: "x", Keywords: []string{`\Seen`}},         // System flag.
+		{HeaderValue: "x", Mailbox: "Lists"},                // Value without name.
+		{SizeMin: 10, SizeMax: 5, Mailbox: "Lists"},         // Bad range.
+		{Subject: "x", Mailbox: "Lists/"},                   // Bad mailbox name.
+		{Subject: "x", Keywords: []string{"has space"}},     // Bad keyword.
+		{From: "x", Mailbox: "Lists", SizeMin: -1},          // Negative size.
+		{From: "x", Keywords: []string{"ok", `\flagged`}},   // System flag.
+		{HeaderName: "List-Id", Keywords: []string{"(bad"}}, // Bad keyword.
+	}
+	for _, r := range bad {
+		_, err := acc.FilterRuleSave(ctxbg, r)
+		if !errors.Is(err, ErrFilterRuleParam) {
+			t.Fatalf("saving rule %#v, got err %v, expected ErrFilterRuleParam", r, err)
+		}
+	}
+
+	r1, err := acc.FilterRuleSave(ctxbg, FilterRule{Name: "lists", HeaderName: "list-id", Mailbox: "Lists", Keywords: []string{"List"}})
+	tcheck(t, err, "save rule")
+	tcompare(t, r1.Keywords, []string{"list"})
+	r2, err := acc.FilterRuleSave(ctxbg, FilterRule{Name: "boss", From: "Boss@mox.example", Flagged: true, ForwardTo: "mjl@other.example"})
+	tcheck(t, err, "save rule")
+	tcompare(t, r2.Position, r1.Position+1)
+
+	rules, err := acc.FilterRules(ctxbg)
+	tcheck(t, err, "list rules")
+	tcompare(t, len(rules), 2)
+	tcompare(t, rules[0].ID, r1.ID)
+
+	// Reorder.
+	err = acc.FilterRulesReorder(ctxbg, []int64{r1.ID})
+	if !errors.Is(err, ErrFilterRuleParam) {
+		t.Fatalf("reorder with missing rule, got err %v, expected ErrFilterRuleParam", err)
+	}
+	err = acc.FilterRulesReorder(ctxbg, []int64{r2.ID, r1.ID})
+	tcheck(t, err, "reorder rules")
+	rules, err = acc.FilterRules(ctxbg)
+	tcheck(t, err, "list rules")
+	tcompare(t, rules[0].ID, r2.ID)
+
+	// Updating keeps position.
+	r1.Subject = "news"
+	r1, err = acc.FilterRuleSave(ctxbg, r1)
+	tcheck(t, err, "update rule")
+	tcompare(t, r1.Position, 1)
+
+	// Matching messages.
+	msg := func(s string) *os.File {
+		t.Helper()
+		f, err := CreateMessageTemp(log, "filter-test")
+		tcheck(t, err, "create temp message")
+		_, err = f.Write([]byte(strings.ReplaceAll(s, "\n", "\r\n")))
+		tcheck(t, err, "write message")
+		return f
+	}
+	match := func(s string) *FilterRule {
+		t.Helper()
+		f := msg(s)
+		defer CloseRemoveTempFile(log, f, "test message")
+		st, err := f.Stat()
+		tcheck(t, err, "stat")
+		return acc.FilterRuleMatch(log, &Message{Size: st.Size()}, f)
+	}
+
+	r := match("From: <boss@mox.example>\nSubject: hi\n\nbody\n")
+	if r == nil || r.ID != r2.ID {
+		t.Fatalf("got rule %v, expected rule %d", r, r2.ID)
+	}
+	r = match("From: <other@mox.example>\nList-Id: <list.mox.example>\nSubject: =?utf-8?q?weekly_News?=\n\nbody\n")
+	if r == nil || r.ID != r1.ID {
+		t.Fatalf("got rule %v, expected rule %d", r, r1.ID)
+	}
+	r = match("From: <other@mox.example>\nList-Id: <list.mox.example>\nSubject: other\n\nbody\n")
+	if r != nil {
+		t.Fatalf("got rule %v, expected no match", r)
+	}
+
+	// Disabled rules don't match.
+	r2.Disabled = true
+	_, err = acc.FilterRuleSave(ctxbg, r2)
+	tcheck(t, err, "disable rule")
+	r = match("From: <boss@mox.example>\nSubject: hi\n\nbody\n")
+	if r != nil {
+		t.Fatalf("got rule %v for disabled rule, expected no match", r)
+	}
+
+	err = acc.FilterRuleRemove(ctxbg, r2.ID)
+	tcheck(t, err, "remove rule")
+	rules, err = acc.FilterRules(ctxbg)
+	tcheck(t, err, "list rules")
+	tcompare(t, len(rules), 1)
+}
@@ -0,0 +1,426 @@
+package store
+
+// Checking and repairing consistency between the database and message files.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+)
+
+// FsckKind is a kind of problem found by Fsck.
+type FsckKind string
+
+const (
+	FsckMissing     FsckKind = "missing"     // Message file is missing. Fixed by removing the message.
+	FsckOrphan      FsckKind = "orphan"      // Message file without message. Fixed by moving the file to the "moved" directory in the data directory.
+	FsckFile        FsckKind = "file"        // Message file cannot be read. Cannot be fixed automatically.
+	FsckSize        FsckKind = "size"        // Message size does not match file. Fixed by setting the size and parsing the message again.
+	FsckParsed      FsckKind = "parsed"      // Stored message structure does not match the file, only with deep check. Fixed by parsing the message again.
+	FsckCounts      FsckKind = "counts"      // Mailbox counts, or total message size of account, are wrong. Fixed by recalculating.
+	FsckUIDNext     FsckKind = "uidnext"     // Mailbox UIDNext not higher than UID of messages. Fixed by setting UIDNext.
+	FsckUIDValidity FsckKind = "uidvalidity" // Mailbox UIDValidity not lower than next UIDValidity of account. Fixed by setting next UIDValidity.
+)
+
+// FsckKinds lists all kinds of problems.
+var FsckKinds = []FsckKind{FsckMissing, FsckOrphan, FsckFile, FsckSize, FsckParsed, FsckCounts, FsckUIDNext, FsckUIDValidity}
+
+// FsckProblem is a problem found by Fsck.
+type FsckProblem struct {
+	Kind      FsckKind
+	MailboxID int64  // If problem is about a mailbox or message.
+	MessageID int64  // If problem is about a message.
+	Path      string // For problems with files.
+	Text      string // Description.
+	Fixed     bool
+}
+
+// FsckOptions configures which checks Fsck does, and which problems it fixes.
+type FsckOptions struct {
+	// Parse all messages and compare their structure with the stored structure. Slow
+	// for large accounts.
+	Deep bool
+
+	// Kinds of problems to fix.
+	Fix []FsckKind
+}
+
+// Fsck checks the account database against the message files and for
+// consistency of mailbox metadata, and fixes the kinds of problems requested.
+//
+// The check is done with the account read lock held, preventing changes to
+// messages, so a large account can block deliveries for a while. Problems are
+// fixed with the write lock held, checking each problem again first.
+func (a *Account) Fsck(ctx context.Context, log mlog.Log, opts FsckOptions) ([]FsckProblem, error) {
+	var problems []FsckProblem
+	var err error
+	a.WithRLock(func() {
+		problems, err = a.fsckCheck(ctx, log, opts.Deep)
+	})
+	if err != nil || len(opts.Fix) == 0 || len(problems) == 0 {
+		return problems, err
+	}
+	a.WithWLock(func() {
+		err = a.fsckFix(ctx, log, problems, opts.Fix)
+	})
+	return problems, err
+}
+
+func (a *Account) fsckCheck(ctx context.Context, log mlog.Log, deep bool) ([]FsckProblem, error) {
+	var problems []FsckProblem
+	key := messageKey(a.Name)
+	referenced := map[string]struct{}{}
+
+	err := a.DB.Read(ctx, func(tx *bstore.Tx) error {
+		nuv := NextUIDValidity{ID: 1}
+		if err := tx.Get(&nuv); err != nil {
+			return fmt.Errorf("get next uidvalidity: %v", err)
+		}
+
+		mailboxes := map[int64]Mailbox{}
+		err := bstore.QueryTx[Mailbox](tx).ForEach(func(mb Mailbox) error {
+			mailboxes[mb.ID] = mb
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("listing mailboxes: %v", err)
+		}
+
+		counts := map[int64]MailboxCounts{}
+		maxUIDs := map[int64]UID{}
+		err = bstore.QueryTx[Message](tx).ForEach(func(m Message) error {
+			// UIDs of expunged messages must not be reused either.
+			maxUIDs[m.MailboxID] = max(maxUIDs[m.MailboxID], m.UID)
+			if m.Expunged {
+				return nil
+			}
+			mc := counts[m.MailboxID]
+			mc.Add(m.MailboxCounts())
+			counts[m.MailboxID] = mc
+
+			mb := mailboxes[m.MailboxID]
+			mp := MessagePath(m.ID)
+			referenced[mp] = struct{}{}
+			p := a.MessagePath(m.ID)
+			problem := func(kind FsckKind, format string, args ...any) {
+				text := fmt.Sprintf("message %d in mailbox %q: ", m.ID, mb.Name) + fmt.Sprintf(format, args...)
+				problems = append(problems, FsckProblem{Kind: kind, MailboxID: mb.ID, MessageID: m.ID, Path: p, Text: text})
+			}
+
+			fileSize, err := MessageFileSize(p, key, m.FileEncrypted, m.FileCompressed)
+			if errors.Is(err, fs.ErrNotExist) {
+				problem(FsckMissing, "message file missing")
+				return nil
+			} else if err != nil {
+				problem(FsckFile, "reading message file: %v", err)
+				return nil
+			} else if m.Size != int64(len(m.Sealed.MsgPrefix))+fileSize {
+				problem(FsckSize, "size %d, should be %d (length of message prefix %d + file size %d)", m.Size, int64(len(m.Sealed.MsgPrefix))+fileSize, len(m.Sealed.MsgPrefix), fileSize)
+				return nil
+			}
+
+			if !deep {
+				return nil
+			}
+			mr := a.MessageReader(m)
+			defer mr.Close()
+			part, err := message.EnsurePart(log.Logger, false, mr, m.Size)
+			if err != nil {
+				// Messages that cannot be parsed are stored with the structure found so far.
+				log.Debugx("parsing message for deep check", err, slog.Int64("msgid", m.ID))
+			}
+			if m.Sealed.ParsedBuf == nil {
+				problem(FsckParsed, "no stored message structure")
+			} else if xpart, err := m.LoadPart(mr); err != nil {
+				problem(FsckParsed, "loading stored message structure: %v", err)
+			} else if err := ComparePartStructure(part, xpart); err != nil {
+				problem(FsckParsed, "stored message structure does not match message file: %v", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("reading messages: %v", err)
+		}
+
+		ids := make([]int64, 0, len(mailboxes))
+		for id := range mailboxes {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+		var totalSize int64
+		for _, id := range ids {
+			mb := mailboxes[id]
+			totalSize += counts[mb.ID].Size
+			problem := func(kind FsckKind, format string, args ...any) {
+				text := fmt.Sprintf("mailbox %q: ", mb.Name) + fmt.Sprintf(format, args...)
+				problems = append(problems, FsckProblem{Kind: kind, MailboxID: mb.ID, Text: text})
+			}
+			if !mb.HaveCounts || mb.MailboxCounts != counts[mb.ID] {
+				problem(FsckCounts, "counts %s, should be %s", mb.MailboxCounts, counts[mb.ID])
+			}
+			if uid := maxUIDs[mb.ID]; uid >= mb.UIDNext {
+				problem(FsckUIDNext, "uidnext %d, should be higher than highest uid %d", mb.UIDNext, uid)
+			}
+			if mb.UIDValidity >= nuv.Next {
+				problem(FsckUIDValidity, "uidvalidity %d, should be lower than next uidvalidity %d of account", mb.UIDValidity, nuv.Next)
+			}
+		}
+
+		du := DiskUsage{ID: 1}
+		if err := tx.Get(&du); err != nil {
+			return fmt.Errorf("get disk usage: %v", err)
+		}
+		if du.MessageSize != totalSize {
+			text := fmt.Sprintf("total message size %d, should be %d", du.MessageSize, totalSize)
+			problems = append(problems, FsckProblem{Kind: FsckCounts, Text: text})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Look for message files without message.
+	dir := filepath.Join(a.Dir, "msg")
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == dir && errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		mp := p[len(dir)+1:]
+		if _, ok := referenced[mp]; !ok {
+			problems = append(problems, FsckProblem{Kind: FsckOrphan, Path: p, Text: fmt.Sprintf("file %s not referenced by a message", filepath.Join("msg", mp))})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walking message files: %v", err)
+	}
+	return problems, nil
+}
+
+func (a *Account) fsckFix(ctx context.Context, log mlog.Log, problems []FsckProblem, fix []FsckKind) error {
+	shouldFix := map[FsckKind]bool{}
+	for _, k := range fix {
+		shouldFix[k] = true
+	}
+
+	var changes []Change
+	err := a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		var modseq ModSeq
+		removeUIDs := map[int64]ChangeRemoveUIDs{}
+		var fixCounts bool
+
+		for i := range problems {
+			pr := &problems[i]
+			if !shouldFix[pr.Kind] {
+				continue
+			}
+
+			switch pr.Kind {
+			case FsckMissing, FsckSize, FsckParsed:
+				m := Message{ID: pr.MessageID}
+				if err := tx.Get(&m); err != nil {
+					return fmt.Errorf("get message: %v", err)
+				}
+				if m.Expunged {
+					continue
+				}
+				mb := Mailbox{ID: m.MailboxID}
+				if err := tx.Get(&mb); err != nil {
+					return fmt.Errorf("get mailbox: %v", err)
+				}
+
+				if pr.Kind == FsckMissing {
+					if _, err := os.Stat(pr.Path); !errors.Is(err, fs.ErrNotExist) {
+						continue
+					}
+					if modseq == 0 {
+						var err error
+						if modseq, err = a.NextModSeq(tx); err != nil {
+							return fmt.Errorf("assigning next modseq: %v", err)
+						}
+					}
+					if _, err := bstore.QueryTx[Recipient](tx).FilterEqual("MessageID", m.ID).Delete(); err != nil {
+						return fmt.Errorf("removing message recipients: %v", err)
+					}
+					mb.Sub(m.MailboxCounts())
+					if err := a.AddMessageSize(log, tx, -m.Size); err != nil {
+						return err
+					}
+					m.Expunged = true
+					m.ModSeq = modseq
+					ch := removeUIDs[mb.ID]
+					ch.MailboxID = mb.ID
+					ch.UIDs = append(ch.UIDs, m.UID)
+					ch.ModSeq = modseq
+					removeUIDs[mb.ID] = ch
+				} else {
+					if pr.Kind == FsckSize {
+						fileSize, err := MessageFileSize(pr.Path, messageKey(a.Name), m.FileEncrypted, m.FileCompressed)
+						if err != nil {
+							return fmt.Errorf("get size of message file: %v", err)
+						}
+						size := int64(len(m.Sealed.MsgPrefix)) + fileSize
+						mb.Size += size - m.Size
+						if err := a.AddMessageSize(log, tx, size-m.Size); err != nil {
+							return err
+						}
+						m.Size = size
+					}
+					mr := a.MessageReader(m)
+					part, err := message.EnsurePart(log.Logger, false, mr, m.Size)
+					log.Check(err, "parsing message, continuing with partial structure", slog.Int64("msgid", m.ID))
+					mr.Close()
+					if m.Sealed.ParsedBuf, err = json.Marshal(part); err != nil {
+						return fmt.Errorf("marshal parsed message: %v", err)
+					}
+				}
+				if err := tx.Update(&m); err != nil {
+					return fmt.Errorf("update message: %v", err)
+				}
+				if err := tx.Update(&mb); err != nil {
+					return fmt.Errorf("update mailbox: %v", err)
+				}
+				changes = append(changes, mb.ChangeCounts())
+				pr.Fixed = true
+
+			case FsckOrphan:
+				mp := pr.Path[len(filepath.Join(a.Dir, "msg"))+1:]
+				npath := mox.DataDirPath(filepath.Join("moved", "accounts", a.Name, "msg", mp))
+				if err := os.MkdirAll(filepath.Dir(npath), 0770); err != nil {
+					return fmt.Errorf("creating directory for moved message file: %v", err)
+				}
+				if err := os.Rename(pr.Path, npath); err != nil {
+					return fmt.Errorf("moving message file: %v", err)
+				}
+				pr.Text += fmt.Sprintf(", moved to %s", npath)
+				pr.Fixed = true
+
+			case FsckCounts:
+				fixCounts = true
+				pr.Fixed = true
+
+			case FsckUIDNext:
+				mb := Mailbox{ID: pr.MailboxID}
+				if err := tx.Get(&mb); err != nil {
+					return fmt.Errorf("get mailbox: %v", err)
+				}
+				m, err := bstore.QueryTx[Message](tx).FilterNonzero(Message{MailboxID: mb.ID}).SortDesc("UID").Limit(1).Get()
+				if err != nil {
+					return fmt.Errorf("get message with highest uid: %v", err)
+				}
+				if m.UID >= mb.UIDNext {
+					mb.UIDNext = m.UID + 1
+					if err := tx.Update(&mb); err != nil {
+						return fmt.Errorf("update mailbox: %v", err)
+					}
+				}
+				pr.Fixed = true
+
+			case FsckUIDValidity:
+				mb := Mailbox{ID: pr.MailboxID}
+				if err := tx.Get(&mb); err != nil {
+					return fmt.Errorf("get mailbox: %v", err)
+				}
+				nuv := NextUIDValidity{ID: 1}
+				if err := tx.Get(&nuv); err != nil {
+					return fmt.Errorf("get next uidvalidity: %v", err)
+				}
+				if mb.UIDValidity >= nuv.Next {
+					nuv.Next = mb.UIDValidity + 1
+					if err := tx.Update(&nuv); err != nil {
+						return fmt.Errorf("update next uidvalidity: %v", err)
+					}
+				}
+				pr.Fixed = true
+			}
+		}
+
+		for _, ch := range removeUIDs {
+			sort.Slice(ch.UIDs, func(i, j int) bool { return ch.UIDs[i] < ch.UIDs[j] })
+			changes = append(changes, ch)
+		}
+
+		if !fixCounts {
+			return nil
+		}
+		var totalSize int64
+		err := bstore.QueryTx[Mailbox](tx).ForEach(func(mb Mailbox) error {
+			mc, err := mb.CalculateCounts(tx)
+			if err != nil {
+				return fmt.Errorf("calculating counts for mailbox %q: %w", mb.Name, err)
+			}
+			totalSize += mc.Size
+			if !mb.HaveCounts || mc != mb.MailboxCounts {
+				mb.HaveCounts = true
+				mb.MailboxCounts = mc
+				if err := tx.Update(&mb); err != nil {
+					return fmt.Errorf("storing new counts for %q: %v", mb.Name, err)
+				}
+				changes = append(changes, mb.ChangeCounts())
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		du := DiskUsage{ID: 1}
+		if err := tx.Get(&du); err != nil {
+			return fmt.Errorf("get disk usage: %v", err)
+		}
+		du.MessageSize = totalSize
+		if err := tx.Update(&du); err != nil {
+			return fmt.Errorf("update disk usage: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		for i := range problems {
+			problems[i].Fixed = false
+		}
+		return err
+	}
+	BroadcastChanges(a, changes)
+	return nil
+}
+
+// ComparePartStructure compares the structure of two parsed forms of a message,
+// typically of a freshly parsed message file and the structure stored in the
+// database, returning an error describing the first difference.
+func ComparePartStructure(p, xp message.Part) error {
+	return comparePartStructure(p, xp, "1")
+}
+
+func comparePartStructure(p, xp message.Part, name string) error {
+	if p.HeaderOffset != xp.HeaderOffset || p.BodyOffset != xp.BodyOffset || p.EndOffset != xp.EndOffset {
+		return fmt.Errorf("part %s: offsets header/body/end %d/%d/%d, stored %d/%d/%d", name, p.HeaderOffset, p.BodyOffset, p.EndOffset, xp.HeaderOffset, xp.BodyOffset, xp.EndOffset)
+	}
+	if len(p.Parts) != len(xp.Parts) {
+		return fmt.Errorf("part %s: %d subparts, stored %d", name, len(p.Parts), len(xp.Parts))
+	}
+	for i := range p.Parts {
+		if err := comparePartStructure(p.Parts[i], xp.Parts[i], fmt.Sprintf("%s.%d", name, i+1)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mox-"
+)
+
+func TestFsck(t *testing.T) {
+	log := pkglog
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	acc, err := OpenAccount(log, "mjl")
+	tcheck(t, err, "open account")
+	defer func() {
+		err = acc.Close()
+		tcheck(t, err, "closing account")
+		acc.CheckClosed()
+	}()
+	defer Switchboard()()
+
+	const msg = "Subject: fsck\r\nContent-Type: multipart/mixed; boundary=x\r\n\r\n--x\r\n\r\none\r\n--x\r\n\r\ntwo\r\n--x--\r\n"
+	deliver := func() Message {
+		t.Helper()
+		msgFile, err := CreateMessageTemp(log, "fsck-test")
+		tcheck(t, err, "create temp message")
+		defer os.Remove(msgFile.Name())
+		defer msgFile.Close()
+		_, err = msgFile.Write([]byte(msg))
+		tcheck(t, err, "write message")
+		m := Message{Received: time.Now(), Size: int64(len(msg))}
+		acc.WithWLock(func() {
+			err = acc.DeliverMailbox(log, "Inbox", &m, msgFile)
+		})
+		tcheck(t, err, "deliver message")
+		return m
+	}
+	m0 := deliver()
+	m1 := deliver()
+	m2 := deliver()
+
+	kinds := func(l []FsckProblem, fixed bool) (r []FsckKind) {
+		for _, p := range l {
+			if p.Fixed == fixed {
+				r = append(r, p.Kind)
+			}
+		}
+		slices.Sort(r)
+		return r
+	}
+
+	problems, err := acc.Fsck(ctxbg, log, FsckOptions{Deep: true})
+	tcheck(t, err, "fsck")
+	tcompare(t, len(problems), 0)
+
+	// Remove message file, add an orphan file, break the size and parsed structure,
+	// the mailbox counts and uidnext.
+	err = os.Remove(acc.MessagePath(m0.ID))
+	tcheck(t, err, "remove message file")
+	err = os.WriteFile(acc.MessagePath(m2.ID+100), []byte("orphan"), 0660)
+	tcheck(t, err, "write orphan")
+	err = acc.DB.Write(ctxbg, func(tx *bstore.Tx) error {
+		m := Message{ID: m1.ID}
+		if err := tx.Get(&m); err != nil {
+			return err
+		}
+		m.Size++
+		if err := tx.Update(&m); err != nil {
+			return err
+		}
+		m = Message{ID: m2.ID}
+		if err := tx.Get(&m); err != nil {
+			return err
+		}
+		m.Sealed.ParsedBuf = []byte(`{}`)
+		if err := tx.Update(&m); err != nil {
+			return err
+		}
+		mb := Mailbox{ID: m2.MailboxID}
+		if err := tx.Get(&mb); err != nil {
+			return err
+		}
+		mb.Unseen = 10
+		mb.UIDNext = m2.UID
+		return tx.Update(&mb)
+	})
+	tcheck(t, err, "breaking database")
+
+	problems, err = acc.Fsck(ctxbg, log, FsckOptions{})
+	tcheck(t, err, "fsck")
+	// Counts of the mailbox, and the total message size of the account.
+	tcompare(t, kinds(problems, false), []FsckKind{FsckCounts, FsckCounts, FsckMissing, FsckOrphan, FsckSize, FsckUIDNext})
+
+	// Only a deep check finds the broken structure. Fix only some problems.
+	problems, err = acc.Fsck(ctxbg, log, FsckOptions{Deep: true, Fix: []FsckKind{FsckOrphan, FsckParsed}})
+	tcheck(t, err, "fsck")
+	tcompare(t, kinds(problems, true), []FsckKind{FsckOrphan, FsckParsed})
+	tcompare(t, kinds(problems, false), []FsckKind{FsckCounts, FsckCounts, FsckMissing, FsckSize, FsckUIDNext})
+	_, err = os.Stat(mox.DataDirPath(filepath.Join("moved", "accounts", "mjl", "msg", MessagePath(m2.ID+100))))
+	tcheck(t, err, "stat moved orphan")
+
+	problems, err = acc.Fsck(ctxbg, log, FsckOptions{Deep: true, Fix: FsckKinds})
+	tcheck(t, err, "fsck")
+	tcompare(t, kinds(problems, false), []FsckKind(nil))
+
+	problems, err = acc.Fsck(ctxbg, log, FsckOptions{Deep: true})
+	tcheck(t, err, "fsck")
+	tcompare(t, len(problems), 0)
+
+	m := Message{ID: m0.ID}
+	err = acc.DB.Get(ctxbg, &m)
+	tcheck(t, err, "get message")
+	tcompare(t, m.Expunged, true)
+}
@@ -0,0 +1,42 @@
+package store
+
+import (
+	"context"
+
+	"github.com/mjl-/bstore"
+)
+
+// Identity holds settings for sending messages from one of the addresses of the
+// account, used by webmail when composing a message with the address in the From
+// header. Empty fields fall back to the account-wide settings.
+type Identity struct {
+	ID        int64
+	Address   string `bstore:"nonzero,unique"` // Unicode user@domain.
+	FullName  string // Display name in From header, instead of configured full name.
+	ReplyTo   string // Address for Reply-To header, optional.
+	Signature string // Signature, instead of the signature in the account settings.
+}
+
+// Identities returns all sending identities of the account.
+func (a *Account) Identities(ctx context.Context) ([]Identity, error) {
+	return bstore.QueryDB[Identity](ctx, a.DB).SortAsc("Address").List()
+}
+
+// IdentitySave stores identity, replacing an existing identity for the same
+// address. The ID of identity is set.
+func (a *Account) IdentitySave(ctx context.Context, identity *Identity) error {
+	return a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		q := bstore.QueryTx[Identity](tx)
+		q.FilterNonzero(Identity{Address: identity.Address})
+		if _, err := q.Delete(); err != nil {
+			return err
+		}
+		identity.ID = 0
+		return tx.Insert(identity)
+	})
+}
+
+// IdentityRemove removes an identity by ID.
+func (a *Account) IdentityRemove(ctx context.Context, id int64) error {
+	return a.DB.Delete(ctx, &Identity{ID: id})
+}
@@ -3,6 +3,7 @@ package store
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -16,6 +17,7 @@ import (
 	"golang.org/x/exp/maps"
 
 	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/pst"
 )
 
 // MsgSource is implemented by readers for mailbox file formats.
@@ -278,32 +280,9 @@ func (mr *MaildirReader) Next() (*Message, *os.File, string, error) {
 		}
 	}()
 
-	// Copy data, changing bare \n into \r\n.
-	r := bufio.NewReader(sf)
-	w := bufio.NewWriter(f)
-	var size int64
-	for {
-		line, err := r.ReadBytes('\n')
-		if err != nil && err != io.EOF {
-			return nil, nil, p, fmt.Errorf("reading message: %v", err)
-		}
-		if len(line) > 0 {
-			if !bytes.HasSuffix(line, []byte("\r\n")) {
-				line = append(line[:len(line)-1], "\r\n"...)
-			}
-
-			if n, err := w.Write(line); err != nil {
-				return nil, nil, p, fmt.Errorf("writing message: %v", err)
-			} else {
-				size += int64(n)
-			}
-		}
-		if err == io.EOF {
-			break
-		}
-	}
-	if err := w.Flush(); err != nil {
-		return nil, nil, p, fmt.Errorf("writing message: %v", err)
+	size, err := copyCRLF(f, sf)
+	if err != nil {
+		return nil, nil, p, err
 	}
 
 	// Take received time from filename, falling back to mtime for maildirs
@@ -370,6 +349,183 @@ func (mr *MaildirReader) Next() (*Message, *os.File, string, error) {
 	return m, mf, p, nil
 }
 
+// EMLReader reads messages from the .eml files in a directory, implementing
+// MsgSource. Flags and received times are taken from file EMLMetadataFile in the
+// directory, as written by an eml export, if present.
+type EMLReader struct {
+	log        mlog.Log
+	createTemp func(log mlog.Log, pattern string) (*os.File, error)
+	dir        string
+	names      []string
+	metadata   map[string]EMLMessage // By file name.
+}
+
+// NewEMLReader returns a reader for the .eml files in dir. Subdirectories are
+// not read.
+func NewEMLReader(log mlog.Log, createTemp func(log mlog.Log, pattern string) (*os.File, error), dir string) (*EMLReader, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	mr := &EMLReader{
+		log:        log,
+		createTemp: createTemp,
+		dir:        dir,
+	}
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(strings.ToLower(e.Name()), ".eml") {
+			mr.names = append(mr.names, e.Name())
+		}
+	}
+
+	// Best-effort parsing of metadata.
+	mf, err := os.Open(filepath.Join(dir, EMLMetadataFile))
+	if err == nil {
+		mr.metadata, err = ParseEMLMetadata(mf)
+		log.Check(err, "parsing eml metadata file")
+		err = mf.Close()
+		log.Check(err, "closing eml metadata file")
+	}
+
+	return mr, nil
+}
+
+func (mr *EMLReader) Next() (*Message, *os.File, string, error) {
+	if len(mr.names) == 0 {
+		return nil, nil, "", io.EOF
+	}
+	name := mr.names[0]
+	mr.names = mr.names[1:]
+
+	p := filepath.Join(mr.dir, name)
+	sf, err := os.Open(p)
+	if err != nil {
+		return nil, nil, p, fmt.Errorf("open eml file: %s", err)
+	}
+	defer func() {
+		err := sf.Close()
+		mr.log.Check(err, "closing eml file")
+	}()
+	f, err := mr.createTemp(mr.log, "emlreader")
+	if err != nil {
+		return nil, nil, p, err
+	}
+	size, err := copyCRLF(f, sf)
+	if err != nil {
+		CloseRemoveTempFile(mr.log, f, "message after eml read error")
+		return nil, nil, p, err
+	}
+
+	m := &Message{Size: size}
+	if md, ok := mr.metadata[name]; ok {
+		m.Received = md.Received
+		m.Flags, m.Keywords, err = ParseFlagsKeywords(md.Flags)
+		if err != nil {
+			mr.log.Infox("parsing flags from eml metadata, ignoring", err, slog.String("path", p))
+			m.Flags, m.Keywords = Flags{}, nil
+		}
+	}
+	return m, f, p, nil
+}
+
+// ParseEMLMetadata parses an EMLMetadataFile, returning the messages by file name.
+func ParseEMLMetadata(r io.Reader) (map[string]EMLMessage, error) {
+	var md EMLMetadata
+	if err := json.NewDecoder(r).Decode(&md); err != nil {
+		return nil, err
+	}
+	l := map[string]EMLMessage{}
+	for _, m := range md.Messages {
+		l[m.File] = m
+	}
+	return l, nil
+}
+
+// PSTReader reads messages from a folder of an Outlook PST file, implementing
+// MsgSource.
+type PSTReader struct {
+	log        mlog.Log
+	createTemp func(log mlog.Log, pattern string) (*os.File, error)
+	f          *pst.File
+	folder     pst.Folder
+	nids       []uint32
+}
+
+// NewPSTReader returns a reader for the messages in folder.
+func NewPSTReader(log mlog.Log, createTemp func(log mlog.Log, pattern string) (*os.File, error), f *pst.File, folder pst.Folder) (*PSTReader, error) {
+	nids, err := f.Messages(folder)
+	if err != nil {
+		return nil, err
+	}
+	return &PSTReader{log, createTemp, f, folder, nids}, nil
+}
+
+func (pr *PSTReader) Next() (*Message, *os.File, string, error) {
+	if len(pr.nids) == 0 {
+		return nil, nil, "", io.EOF
+	}
+	nid := pr.nids[0]
+	pr.nids = pr.nids[1:]
+
+	pos := fmt.Sprintf("%s, message %#x", strings.Join(pr.folder.Path, "/"), nid)
+	pm, err := pr.f.Message(nid)
+	if err != nil {
+		return nil, nil, pos, fmt.Errorf("reading message from pst file: %v", err)
+	}
+	f, err := pr.createTemp(pr.log, "pstreader")
+	if err != nil {
+		return nil, nil, pos, err
+	}
+	if _, err := f.Write(pm.Data); err != nil {
+		CloseRemoveTempFile(pr.log, f, "message after pst write error")
+		return nil, nil, pos, fmt.Errorf("writing message: %v", err)
+	}
+
+	m := &Message{
+		Received: pm.Received,
+		Flags: Flags{
+			Seen:      pm.Seen,
+			Answered:  pm.Answered,
+			Flagged:   pm.Flagged,
+			Forwarded: pm.Forwarded,
+			Draft:     pm.Draft,
+		},
+		Size: int64(len(pm.Data)),
+	}
+	return m, f, pos, nil
+}
+
+// copyCRLF copies a message from r to w, changing bare \n into \r\n.
+func copyCRLF(w io.Writer, r io.Reader) (int64, error) {
+	br := bufio.NewReader(r)
+	bw := bufio.NewWriter(w)
+	var size int64
+	for {
+		line, err := br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return 0, fmt.Errorf("reading message: %v", err)
+		}
+		if len(line) > 0 {
+			if !bytes.HasSuffix(line, []byte("\r\n")) {
+				line = append(line[:len(line)-1], "\r\n"...)
+			}
+
+			if n, err := bw.Write(line); err != nil {
+				return 0, fmt.Errorf("writing message: %v", err)
+			} else {
+				size += int64(n)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return 0, fmt.Errorf("writing message: %v", err)
+	}
+	return size, nil
+}
+
 // ParseDovecotKeywordsFlags attempts to parse a dovecot-keywords file. It only
 // returns valid flags/keywords, as lower-case. If an error is encountered and
 // returned, any keywords that were found are still returned. The returned list has
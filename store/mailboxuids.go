@@ -0,0 +1,109 @@
+package store
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/mjl-/bstore"
+)
+
+// mailboxUIDs holds the UIDs of non-expunged messages of mailboxes that were
+// requested through MailboxUIDs, kept up to date with broadcasted changes. IMAP
+// needs all UIDs of a mailbox when selecting it, for message sequence numbers.
+// Reading them from the database requires reading all messages, which is slow
+// for mailboxes with many messages.
+type mailboxUIDs struct {
+	sync.Mutex
+	m map[int64]cachedUIDs // By mailbox ID.
+}
+
+type cachedUIDs struct {
+	uidValidity uint32
+	uids        []UID // In increasing order.
+}
+
+// apply updates the cached UIDs with changes.
+func (mu *mailboxUIDs) apply(changes []Change) {
+	mu.Lock()
+	defer mu.Unlock()
+	if len(mu.m) == 0 {
+		return
+	}
+	for _, ch := range changes {
+		switch x := ch.(type) {
+		case ChangeAddUID:
+			cu, ok := mu.m[x.MailboxID]
+			if !ok {
+				continue
+			}
+			// Usually appended, but broadcasts of concurrent deliveries can be reordered.
+			if i, found := slices.BinarySearch(cu.uids, x.UID); !found {
+				cu.uids = slices.Insert(cu.uids, i, x.UID)
+				mu.m[x.MailboxID] = cu
+			}
+		case ChangeRemoveUIDs:
+			cu, ok := mu.m[x.MailboxID]
+			if !ok {
+				continue
+			}
+			cu.uids = slices.DeleteFunc(cu.uids, func(uid UID) bool {
+				_, found := slices.BinarySearch(x.UIDs, uid)
+				return found
+			})
+			mu.m[x.MailboxID] = cu
+		case ChangeRemoveMailbox:
+			delete(mu.m, x.MailboxID)
+		}
+	}
+}
+
+// MailboxUIDs returns the UIDs of the non-expunged messages in mailbox mb, in
+// increasing order, and whether they were available. The caller can modify the
+// returned slice. The account lock must be held, and mb must be read in tx.
+//
+// The UIDs are kept in memory per mailbox, and updated with broadcasted changes.
+// They are only returned while they are consistent with the message counts,
+// UIDNext and UIDValidity of mb. Otherwise, if fill is set, the UIDs are read
+// from the database and kept in memory, and if fill is not set, false is
+// returned. Fill must only be set with the account write lock held: Changes
+// broadcasted by concurrent operations while reading from the database would be
+// lost.
+func (a *Account) MailboxUIDs(tx *bstore.Tx, mb Mailbox, fill bool) ([]UID, bool, error) {
+	n := mb.Total + mb.Deleted
+
+	a.mailboxUIDs.Lock()
+	cu, ok := a.mailboxUIDs.m[mb.ID]
+	if ok && cu.uidValidity == mb.UIDValidity && int64(len(cu.uids)) == n && (n == 0 || cu.uids[n-1] < mb.UIDNext) {
+		uids := slices.Clone(cu.uids)
+		a.mailboxUIDs.Unlock()
+		return uids, true, nil
+	}
+	a.mailboxUIDs.Unlock()
+
+	if !fill {
+		return nil, false, nil
+	}
+
+	q := bstore.QueryTx[Message](tx)
+	q.FilterNonzero(Message{MailboxID: mb.ID})
+	q.FilterEqual("Expunged", false)
+	q.SortAsc("UID")
+	// Counts are kept up to date, we allocate once for large mailboxes.
+	uids := make([]UID, 0, n)
+	err := q.ForEach(func(m Message) error {
+		uids = append(uids, m.UID)
+		return nil
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("listing message uids: %v", err)
+	}
+
+	a.mailboxUIDs.Lock()
+	defer a.mailboxUIDs.Unlock()
+	if a.mailboxUIDs.m == nil {
+		a.mailboxUIDs.m = map[int64]cachedUIDs{}
+	}
+	a.mailboxUIDs.m[mb.ID] = cachedUIDs{mb.UIDValidity, slices.Clone(uids)}
+	return uids, true, nil
+}
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mox-"
+)
+
+func TestMailboxUIDs(t *testing.T) {
+	log := pkglog
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	acc, err := OpenAccount(log, "mjl")
+	tcheck(t, err, "open account")
+	defer func() {
+		err = acc.Close()
+		tcheck(t, err, "closing account")
+		acc.CheckClosed()
+	}()
+	defer Switchboard()()
+
+	const msg = "Subject: hi\r\n\r\nbody\r\n"
+	deliver := func() {
+		t.Helper()
+		msgFile, err := CreateMessageTemp(log, "mailboxuids-test")
+		tcheck(t, err, "create temp message")
+		defer os.Remove(msgFile.Name())
+		defer msgFile.Close()
+		_, err = msgFile.Write([]byte(msg))
+		tcheck(t, err, "write message")
+		m := &Message{Received: time.Now(), Size: int64(len(msg))}
+		err = acc.DeliverGroup(log, "Inbox", m, msgFile)
+		tcheck(t, err, "deliver")
+	}
+
+	test := func(fill bool, expUIDs []UID, expOK bool) {
+		t.Helper()
+		err := acc.DB.Read(ctxbg, func(tx *bstore.Tx) error {
+			mb, err := bstore.QueryTx[Mailbox](tx).FilterNonzero(Mailbox{Name: "Inbox"}).Get()
+			tcheck(t, err, "get inbox")
+			uids, ok, err := acc.MailboxUIDs(tx, mb, fill)
+			tcheck(t, err, "mailbox uids")
+			tcompare(t, ok, expOK)
+			tcompare(t, uids, expUIDs)
+			return nil
+		})
+		tcheck(t, err, "read")
+	}
+
+	deliver()
+	deliver()
+	inbox, err := bstore.QueryDB[Mailbox](ctxbg, acc.DB).FilterNonzero(Mailbox{Name: "Inbox"}).Get()
+	tcheck(t, err, "get inbox")
+
+	// Not in memory yet, only read from the database when filling.
+	test(false, nil, false)
+	test(true, []UID{1, 2}, true)
+	test(false, []UID{1, 2}, true)
+
+	// Kept up to date with broadcasted changes.
+	deliver()
+	test(false, []UID{1, 2, 3}, true)
+
+	// Not used when inconsistent with the mailbox.
+	BroadcastChanges(acc, []Change{ChangeRemoveUIDs{MailboxID: inbox.ID, UIDs: []UID{2}}})
+	test(false, nil, false)
+	test(true, []UID{1, 2, 3}, true)
+
+	// Forgotten when the mailbox is removed.
+	BroadcastChanges(acc, []Change{ChangeRemoveMailbox{MailboxID: inbox.ID}})
+	test(false, nil, false)
+}
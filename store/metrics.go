@@ -0,0 +1,82 @@
+package store
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+)
+
+var (
+	metricAccountSize = prometheus.NewDesc(
+		"mox_store_account_size_bytes",
+		"Total size of messages of an account, as used for quota. Only exported when enabled with MetricsSeries in mox.conf.",
+		[]string{"account"}, nil,
+	)
+	metricAccountMessages = prometheus.NewDesc(
+		"mox_store_account_messages",
+		"Number of messages of an account, including those marked deleted. Only exported when enabled with MetricsSeries in mox.conf.",
+		[]string{"account"}, nil,
+	)
+)
+
+func init() {
+	prometheus.MustRegister(accountCollector{})
+}
+
+// accountCollector gathers storage usage per account when metrics are requested,
+// if enabled in the configuration.
+type accountCollector struct{}
+
+func (accountCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- metricAccountSize
+	ch <- metricAccountMessages
+}
+
+func (accountCollector) Collect(ch chan<- prometheus.Metric) {
+	if ms := mox.Conf.Static.MetricsSeries; ms == nil || !ms.AccountStorage {
+		return
+	}
+
+	log := mlog.New("store", nil)
+	ctx, cancel := context.WithTimeout(mox.Shutdown, 30*time.Second)
+	defer cancel()
+
+	for _, name := range mox.Conf.Accounts() {
+		var size, count int64
+		err := func() error {
+			acc, err := OpenAccount(log, name)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				err := acc.Close()
+				log.Check(err, "closing account after gathering metrics")
+			}()
+
+			return acc.DB.Read(ctx, func(tx *bstore.Tx) error {
+				du := DiskUsage{ID: 1}
+				if err := tx.Get(&du); err != nil {
+					return err
+				}
+				size = du.MessageSize
+				return bstore.QueryTx[Mailbox](tx).ForEach(func(mb Mailbox) error {
+					count += mb.Total + mb.Deleted
+					return nil
+				})
+			})
+		}()
+		if err != nil {
+			log.Errorx("gathering account storage metrics", err, slog.String("account", name))
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(metricAccountSize, prometheus.GaugeValue, float64(size), name)
+		ch <- prometheus.MustNewConstMetric(metricAccountMessages, prometheus.GaugeValue, float64(count), name)
+	}
+}
@@ -0,0 +1,26 @@
+package store
+
+import (
+	"time"
+)
+
+// MigrationMailbox is the progress of migrating a mailbox from a remote IMAP
+// server, for resuming an interrupted migration, and for later passes that only
+// fetch new messages and update flags.
+type MigrationMailbox struct {
+	ID          int64
+	Remote      string `bstore:"nonzero,index Remote+RemoteName"` // Username and host of the remote account.
+	RemoteName  string `bstore:"nonzero"`                         // Mailbox name at the remote server.
+	UIDValidity uint32 // Of the remote mailbox. If it changes, the mailbox is migrated again.
+	LastUID     uint32 // Highest UID of the remote mailbox that was migrated.
+	Updated     time.Time
+}
+
+// MigrationMessage links a message migrated from a remote IMAP server to the
+// local message, for updating flags in later passes.
+type MigrationMessage struct {
+	ID                 int64
+	MigrationMailboxID int64  `bstore:"nonzero,ref MigrationMailbox"`
+	RemoteUID          uint32 `bstore:"nonzero"`
+	MessageID          int64  `bstore:"nonzero"` // Local message, may have been removed since.
+}
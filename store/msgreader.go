@@ -11,12 +11,18 @@ import (
 // database (typically received headers), followed by the on-disk msg file
 // contents. MsgReader is an io.Reader, io.ReaderAt and io.Closer.
 type MsgReader struct {
-	prefix []byte   // First part of the message. Typically contains received headers.
-	path   string   // To on-disk message file.
-	size   int64    // Total size of message, including prefix and contents from path.
-	offset int64    // Current reading offset.
-	f      *os.File // Opened path, automatically opened after prefix has been read.
-	err    error    // If set, error to return for reads. Sets io.EOF for readers, but ReadAt ignores them.
+	prefix     []byte      // First part of the message. Typically contains received headers.
+	path       string      // To on-disk message file.
+	key        []byte      // For decrypting the on-disk message file if it is encrypted, nil if encryption is not configured.
+	encrypted  bool        // Whether the on-disk message file is encrypted, from Message.FileEncrypted.
+	compressed bool        // Whether the on-disk message file is compressed, from Message.FileCompressed.
+	acc        *Account    // If set, the format of the file is looked up in the account before opening, for files rewritten after the message was read.
+	id         int64       // Message ID, for looking up the file format in acc.
+	size       int64       // Total size of message, including prefix and contents from path.
+	offset     int64       // Current reading offset.
+	f          messageFile // Opened path, automatically opened after prefix has been read.
+	shared     bool        // Whether f was passed in, its file offset must not be changed.
+	err        error       // If set, error to return for reads. Sets io.EOF for readers, but ReadAt ignores them.
 }
 
 var errMsgClosed = errors.New("msg is closed")
@@ -25,7 +31,7 @@ var errMsgClosed = errors.New("msg is closed")
 // If initialization fails, reads will return the error.
 // Only call close on the returned MsgReader if you want to close msgFile.
 func FileMsgReader(prefix []byte, msgFile *os.File) *MsgReader {
-	mr := &MsgReader{prefix: prefix, path: msgFile.Name(), f: msgFile}
+	mr := &MsgReader{prefix: prefix, path: msgFile.Name(), f: msgFile, shared: true}
 	fi, err := msgFile.Stat()
 	if err != nil {
 		mr.err = err
@@ -35,6 +41,31 @@ func FileMsgReader(prefix []byte, msgFile *os.File) *MsgReader {
 	return mr
 }
 
+// PathMsgReader makes a MsgReader for the message file at path, e.g. in a copy of
+// a data directory. Encrypted and compressed describe the format of the file, see
+// Message.FileEncrypted and Message.FileCompressed. Key is used to decrypt the
+// file, it can be nil for files that are not encrypted. Size is the size of the
+// message, including prefix.
+func PathMsgReader(prefix []byte, path string, key []byte, encrypted, compressed bool, size int64) *MsgReader {
+	return &MsgReader{prefix: prefix, path: path, key: key, encrypted: encrypted, compressed: compressed, size: size}
+}
+
+// openFile opens the on-disk message file. For a MsgReader for an account, the
+// file format is looked up in the account first, the file may have been rewritten
+// by CompressMessageFiles after the message was read from the database.
+func (m *MsgReader) openFile() (messageFile, error) {
+	encrypted, compressed := m.encrypted, m.compressed
+	if m.acc != nil {
+		m.acc.fileFormats.RLock()
+		defer m.acc.fileFormats.RUnlock()
+		if ff, ok := m.acc.fileFormats.m[m.id]; ok {
+			encrypted, compressed = ff.encrypted, ff.compressed
+		}
+	}
+	f, _, err := openMessageFile(m.path, m.key, encrypted, compressed)
+	return f, err
+}
+
 // Read reads data from the msg, taking prefix and on-disk msg file into account.
 // The read offset is adjusted after the read.
 func (m *MsgReader) Read(buf []byte) (int, error) {
@@ -73,7 +104,7 @@ func (m *MsgReader) read(buf []byte, off int64, pread bool) (int, error) {
 
 		// Now we need to read from file. Ensure it is open.
 		if m.f == nil {
-			f, err := os.Open(m.path)
+			f, err := m.openFile()
 			if err != nil {
 				m.err = err
 				break
@@ -108,6 +139,56 @@ func (m *MsgReader) read(buf []byte, off int64, pread bool) (int, error) {
 	return o, m.err
 }
 
+// WriteTo writes the remainder of the message, from the current read offset, to
+// w, and adjusts the offset. For plain on-disk message files, i.e. not encrypted
+// or compressed, the file itself is passed to io.Copy, so writers like network
+// connections can use zero-copy mechanisms like sendfile.
+func (m *MsgReader) WriteTo(w io.Writer) (int64, error) {
+	if m.err == io.EOF {
+		return 0, nil
+	} else if m.err != nil {
+		return 0, m.err
+	}
+
+	var written int64
+	if m.offset < int64(len(m.prefix)) {
+		n, err := w.Write(m.prefix[m.offset:])
+		m.offset += int64(n)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	if m.f == nil {
+		f, err := m.openFile()
+		if err != nil {
+			m.err = err
+			return written, err
+		}
+		m.f = f
+	}
+	off := m.offset - int64(len(m.prefix))
+	var r io.Reader
+	if f, ok := m.f.(*os.File); ok && !m.shared {
+		// Reads with ReadAt are not affected by the file offset.
+		if _, err := f.Seek(off, io.SeekStart); err != nil {
+			m.err = err
+			return written, err
+		}
+		r = io.LimitReader(f, m.size-m.offset)
+	} else {
+		r = io.NewSectionReader(m.f, off, m.size-m.offset)
+	}
+	n, err := io.Copy(w, r)
+	m.offset += n
+	written += n
+	if err == nil && m.offset < m.size {
+		err = fmt.Errorf("on-disk message smaller than expected (off %d, size %d)", m.offset, m.size)
+		m.err = err
+	}
+	return written, err
+}
+
 // Close ensures the msg file is closed. Further reads will fail.
 func (m *MsgReader) Close() error {
 	if m.f != nil {
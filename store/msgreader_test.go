@@ -3,6 +3,7 @@ package store
 import (
 	"io"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -81,4 +82,27 @@ func TestMsgreader(t *testing.T) {
 	if err := mr.Close(); err != nil {
 		t.Fatalf("close: %v", err)
 	}
+
+	// WriteTo, used by io.Copy, from the start and after a partial read.
+	mr = &MsgReader{prefix: []byte("hello"), path: "msgreader_test.txt", size: int64(len("hello world"))}
+	defer mr.Close()
+	var sb strings.Builder
+	if n, err := io.Copy(&sb, mr); err != nil || n != int64(len("hello world")) || sb.String() != "hello world" {
+		t.Fatalf("writeto: got n %d, err %v, data %q", n, err, sb.String())
+	}
+	mr.Reset()
+	if _, err := io.ReadFull(mr, make([]byte, 7)); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	sb.Reset()
+	if n, err := io.Copy(&sb, mr); err != nil || n != int64(len("orld")) || sb.String() != "orld" {
+		t.Fatalf("writeto after read: got n %d, err %v, data %q", n, err, sb.String())
+	}
+
+	// File smaller than expected.
+	mr = &MsgReader{prefix: []byte("hello"), path: "msgreader_test.txt", size: int64(len("hello world!"))}
+	defer mr.Close()
+	if _, err := io.Copy(io.Discard, mr); err == nil {
+		t.Fatalf("writeto: expected error for short file")
+	}
 }
@@ -0,0 +1,18 @@
+//go:build !windows
+
+package store
+
+import (
+	"fmt"
+	"io/fs"
+	"syscall"
+)
+
+// fileLinks returns the number of hard links to a file.
+func fileLinks(fi fs.FileInfo) (int64, error) {
+	x, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, fmt.Errorf("stat sys is a %T, expected *syscall.Stat_t", fi.Sys())
+	}
+	return int64(x.Nlink), nil
+}
@@ -0,0 +1,14 @@
+//go:build windows
+
+package store
+
+import (
+	"errors"
+	"io/fs"
+)
+
+// fileLinks returns the number of hard links to a file. Not implemented on
+// Windows, deduplication of message files is not supported.
+func fileLinks(fi fs.FileInfo) (int64, error) {
+	return 0, errors.New("number of hard links not available on windows")
+}
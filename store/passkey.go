@@ -0,0 +1,95 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/webauthn"
+)
+
+// ErrPasskeyParam is returned when adding a passkey with invalid parameters.
+var ErrPasskeyParam = errors.New("invalid passkey parameter")
+
+// Passkey is a WebAuthn public key credential for logging in to the account and
+// webmail web interfaces without password. An account can have multiple
+// passkeys, e.g. one per device.
+type Passkey struct {
+	ID           int64
+	CredentialID string    `bstore:"nonzero,unique" json:"-"` // Base64url-encoded, chosen by the authenticator.
+	Label        string    `bstore:"nonzero"`                 // Description, e.g. device.
+	LoginAddress string    `bstore:"nonzero"`                 // Address used for sessions after logging in with this passkey.
+	PublicKey    []byte    `json:"-"`                         // COSE encoded.
+	SignCount    uint32    `json:"-"`                         // To detect cloned authenticators, for authenticators that count.
+	Created      time.Time `bstore:"nonzero,default now"`
+	LastUsed     time.Time // Zero if never used.
+}
+
+// Credential returns the passkey as credential for verifying an assertion.
+func (p Passkey) Credential() webauthn.Credential {
+	id, _ := webauthn.Base64.DecodeString(p.CredentialID)
+	return webauthn.Credential{ID: id, PublicKey: p.PublicKey, SignCount: p.SignCount}
+}
+
+// PasskeyAdd adds a verified credential as passkey.
+func (a *Account) PasskeyAdd(ctx context.Context, label, loginAddress string, cred webauthn.Credential) (Passkey, error) {
+	label = strings.TrimSpace(label)
+	if label == "" {
+		return Passkey{}, fmt.Errorf("%w: label required", ErrPasskeyParam)
+	}
+	p := Passkey{
+		CredentialID: webauthn.Base64.EncodeToString(cred.ID),
+		Label:        label,
+		LoginAddress: loginAddress,
+		PublicKey:    cred.PublicKey,
+		SignCount:    cred.SignCount,
+		Created:      time.Now(),
+	}
+	err := a.DB.Insert(ctx, &p)
+	if err != nil && errors.Is(err, bstore.ErrUnique) {
+		return Passkey{}, fmt.Errorf("%w: passkey already registered", ErrPasskeyParam)
+	} else if err != nil {
+		return Passkey{}, fmt.Errorf("inserting passkey: %v", err)
+	}
+	return p, nil
+}
+
+// Passkeys returns all passkeys of the account.
+func (a *Account) Passkeys(ctx context.Context) ([]Passkey, error) {
+	return bstore.QueryDB[Passkey](ctx, a.DB).SortAsc("Created").List()
+}
+
+// PasskeyGet returns the passkey for a base64url-encoded credential ID, or
+// bstore.ErrAbsent.
+func (a *Account) PasskeyGet(ctx context.Context, credentialID string) (Passkey, error) {
+	return bstore.QueryDB[Passkey](ctx, a.DB).FilterNonzero(Passkey{CredentialID: credentialID}).Get()
+}
+
+// PasskeyUsed records a successful login with a passkey, with its new signature
+// counter.
+func (a *Account) PasskeyUsed(ctx context.Context, id int64, signCount uint32) error {
+	return a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		p := Passkey{ID: id}
+		if err := tx.Get(&p); err != nil {
+			return err
+		}
+		p.SignCount = signCount
+		p.LastUsed = time.Now()
+		return tx.Update(&p)
+	})
+}
+
+// PasskeyRemove removes a passkey.
+func (a *Account) PasskeyRemove(ctx context.Context, id int64) error {
+	return a.DB.Delete(ctx, &Passkey{ID: id})
+}
+
+// PasskeysReset removes all passkeys of the account, e.g. by an admin when a user
+// lost access to devices.
+func (a *Account) PasskeysReset(ctx context.Context) (removed int, rerr error) {
+	return bstore.QueryDB[Passkey](ctx, a.DB).Delete()
+}
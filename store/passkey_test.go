@@ -0,0 +1,81 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/webauthn"
+)
+
+func TestPasskey(t *testing.T) {
+	log := mlog.New("store", nil)
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	acc, err := OpenAccount(log, "mjl")
+	tcheck(t, err, "open account")
+	defer func() {
+		err = acc.Close()
+		tcheck(t, err, "closing account")
+		acc.CheckClosed()
+	}()
+
+	cred := webauthn.Credential{ID: []byte("credential"), PublicKey: []byte("key"), SignCount: 1}
+
+	_, err = acc.PasskeyAdd(ctxbg, " ", "mjl@mox.example", cred)
+	if !errors.Is(err, ErrPasskeyParam) {
+		t.Fatalf("adding passkey without label: got err %v, expected ErrPasskeyParam", err)
+	}
+
+	p, err := acc.PasskeyAdd(ctxbg, "laptop", "mjl@mox.example", cred)
+	tcheck(t, err, "add passkey")
+	if p.CredentialID != webauthn.Base64.EncodeToString(cred.ID) {
+		t.Fatalf("got credential id %q, expected base64url of %q", p.CredentialID, cred.ID)
+	}
+	_, err = acc.PasskeyAdd(ctxbg, "laptop again", "mjl@mox.example", cred)
+	if !errors.Is(err, ErrPasskeyParam) {
+		t.Fatalf("adding duplicate passkey: got err %v, expected ErrPasskeyParam", err)
+	}
+
+	xp, err := acc.PasskeyGet(ctxbg, p.CredentialID)
+	tcheck(t, err, "get passkey")
+	if c := xp.Credential(); string(c.ID) != string(cred.ID) || string(c.PublicKey) != string(cred.PublicKey) || c.SignCount != 1 {
+		t.Fatalf("got credential %v, expected %v", c, cred)
+	}
+	_, err = acc.PasskeyGet(ctxbg, "unknown")
+	if !errors.Is(err, bstore.ErrAbsent) {
+		t.Fatalf("get unknown passkey: got err %v, expected ErrAbsent", err)
+	}
+
+	err = acc.PasskeyUsed(ctxbg, p.ID, 2)
+	tcheck(t, err, "passkey used")
+	xp, err = acc.PasskeyGet(ctxbg, p.CredentialID)
+	tcheck(t, err, "get passkey")
+	if xp.SignCount != 2 || xp.LastUsed.IsZero() {
+		t.Fatalf("passkey not updated after use, sign count %d, last used %v", xp.SignCount, xp.LastUsed)
+	}
+
+	err = acc.PasskeyRemove(ctxbg, p.ID)
+	tcheck(t, err, "remove passkey")
+	l, err := acc.Passkeys(ctxbg)
+	tcheck(t, err, "list passkeys")
+	if len(l) != 0 {
+		t.Fatalf("got %d passkeys after remove, expected 0", len(l))
+	}
+
+	_, err = acc.PasskeyAdd(ctxbg, "phone", "mjl@mox.example", webauthn.Credential{ID: []byte("a")})
+	tcheck(t, err, "add passkey")
+	_, err = acc.PasskeyAdd(ctxbg, "key", "mjl@mox.example", webauthn.Credential{ID: []byte("b")})
+	tcheck(t, err, "add passkey")
+	n, err := acc.PasskeysReset(ctxbg)
+	tcheck(t, err, "reset passkeys")
+	if n != 2 {
+		t.Fatalf("reset removed %d passkeys, expected 2", n)
+	}
+}
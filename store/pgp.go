@@ -0,0 +1,252 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/autocrypt"
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/smtp"
+)
+
+// ErrPGPKeyParam is returned when importing an invalid OpenPGP key.
+var ErrPGPKeyParam = errors.New("invalid openpgp key parameter")
+
+// PGPKey is an OpenPGP key, either of the account itself (with a private key,
+// used by webmail for decrypting and signing), or of a correspondent (used for
+// encrypting messages to them). Keys of correspondents are imported by the user,
+// or learned from Autocrypt headers in incoming messages.
+//
+// Encryption, decryption and signing is done in the browser. The server only
+// stores the private key as armored data that is encrypted with a passphrase by
+// the OpenPGP implementation.
+type PGPKey struct {
+	ID          int64
+	Created     time.Time `bstore:"nonzero,default now"`
+	Address     string    `bstore:"nonzero,index"` // Normalized email address the key is for.
+	Fingerprint string    `bstore:"nonzero"`       // Of primary key, upper case hex.
+	UserIDs     []string
+	PublicKey   string `bstore:"nonzero"` // Armored transferable public key.
+	Own         bool   // Whether this is a key of the account itself, with a private key.
+	PrivateKey  string `json:"-"` // For own keys, armored and encrypted with a passphrase.
+
+	Autocrypt  bool // For own keys, whether the public key is added as Autocrypt header to messages sent from webmail.
+	WKDPublish bool // For own keys, whether the public key is published in the Web Key Directory.
+
+	Source             string    // "import" or "autocrypt".
+	PreferEncrypt      bool      // From Autocrypt header.
+	AutocryptTimestamp time.Time // Date of the most recent message with an Autocrypt header for the address.
+}
+
+// parsePGPPublicKey parses an armored transferable public key, returning the
+// parsed key and its armored form as stored.
+func parsePGPPublicKey(s string) (autocrypt.Key, string, error) {
+	typ, buf, err := autocrypt.Unarmor(s)
+	if err != nil {
+		return autocrypt.Key{}, "", fmt.Errorf("%w: %v", ErrPGPKeyParam, err)
+	}
+	if typ != "PGP PUBLIC KEY BLOCK" {
+		return autocrypt.Key{}, "", fmt.Errorf("%w: armor type %q, expected public key block", ErrPGPKeyParam, typ)
+	}
+	k, err := autocrypt.ParseKey(buf)
+	if err != nil {
+		return autocrypt.Key{}, "", fmt.Errorf("%w: %v", ErrPGPKeyParam, err)
+	}
+	if k.Secret {
+		return autocrypt.Key{}, "", fmt.Errorf("%w: secret key instead of public key", ErrPGPKeyParam)
+	}
+	return k, autocrypt.Armor("PGP PUBLIC KEY BLOCK", buf), nil
+}
+
+// PGPKeys returns all OpenPGP keys of the account, own keys first, then sorted by
+// address.
+func (a *Account) PGPKeys(ctx context.Context) ([]PGPKey, error) {
+	l, err := bstore.QueryDB[PGPKey](ctx, a.DB).List()
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(l, func(x, y PGPKey) int {
+		if x.Own != y.Own {
+			if x.Own {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(x.Address, y.Address)
+	})
+	return l, nil
+}
+
+// PGPKeyImport adds an armored public key for address. If privateKey is
+// non-empty, it must be an armored, passphrase-protected secret key for the same
+// key, and the key is added as own key, for which address must belong to the
+// account. Existing keys for the same address and ownership are replaced.
+func (a *Account) PGPKeyImport(ctx context.Context, address, publicKey, privateKey string) (PGPKey, error) {
+	addr, err := smtp.ParseAddress(strings.TrimSpace(address))
+	if err != nil {
+		return PGPKey{}, fmt.Errorf("%w: invalid address: %v", ErrPGPKeyParam, err)
+	}
+	k, armored, err := parsePGPPublicKey(publicKey)
+	if err != nil {
+		return PGPKey{}, err
+	}
+	own := privateKey != ""
+	if own {
+		typ, buf, err := autocrypt.Unarmor(privateKey)
+		if err != nil {
+			return PGPKey{}, fmt.Errorf("%w: private key: %v", ErrPGPKeyParam, err)
+		}
+		if typ != "PGP PRIVATE KEY BLOCK" {
+			return PGPKey{}, fmt.Errorf("%w: armor type %q, expected private key block", ErrPGPKeyParam, typ)
+		}
+		sk, err := autocrypt.ParseKey(buf)
+		if err != nil {
+			return PGPKey{}, fmt.Errorf("%w: private key: %v", ErrPGPKeyParam, err)
+		} else if !sk.Secret || sk.Fingerprint != k.Fingerprint {
+			return PGPKey{}, fmt.Errorf("%w: private key does not match public key", ErrPGPKeyParam)
+		}
+		conf, _ := a.Conf()
+		if _, ok := conf.Destinations[addr.String()]; !ok {
+			return PGPKey{}, fmt.Errorf("%w: address does not belong to account", ErrPGPKeyParam)
+		}
+	}
+
+	pk := PGPKey{
+		Address:     addr.String(),
+		Fingerprint: k.Fingerprint,
+		UserIDs:     k.UserIDs,
+		PublicKey:   armored,
+		Own:         own,
+		PrivateKey:  privateKey,
+		Autocrypt:   own,
+		Source:      "import",
+	}
+	err = a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		q := bstore.QueryTx[PGPKey](tx)
+		q.FilterNonzero(PGPKey{Address: pk.Address})
+		q.FilterEqual("Own", own)
+		if _, err := q.Delete(); err != nil {
+			return fmt.Errorf("removing previous key: %v", err)
+		}
+		return tx.Insert(&pk)
+	})
+	return pk, err
+}
+
+// PGPKeySave saves the Autocrypt and WKDPublish settings of an own key.
+func (a *Account) PGPKeySave(ctx context.Context, id int64, autocrypt, wkdPublish bool) error {
+	return a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		pk := PGPKey{ID: id}
+		if err := tx.Get(&pk); err != nil {
+			return err
+		}
+		if !pk.Own {
+			return fmt.Errorf("%w: not an own key", ErrPGPKeyParam)
+		}
+		pk.Autocrypt = autocrypt
+		pk.WKDPublish = wkdPublish
+		return tx.Update(&pk)
+	})
+}
+
+// PGPKeyRemove removes an OpenPGP key.
+func (a *Account) PGPKeyRemove(ctx context.Context, id int64) error {
+	return a.DB.Delete(ctx, &PGPKey{ID: id})
+}
+
+// PGPKeyOwn returns the own key for address, for a normalized address. If there is
+// no key, bstore.ErrAbsent is returned.
+func (a *Account) PGPKeyOwn(ctx context.Context, address string) (PGPKey, error) {
+	q := bstore.QueryDB[PGPKey](ctx, a.DB)
+	q.FilterNonzero(PGPKey{Address: address})
+	q.FilterEqual("Own", true)
+	return q.Get()
+}
+
+// PGPKeysPeer returns the keys of correspondents for the normalized addresses.
+// Addresses without key are not in the result.
+func (a *Account) PGPKeysPeer(ctx context.Context, addresses []string) ([]PGPKey, error) {
+	if len(addresses) == 0 {
+		return nil, nil
+	}
+	l := make([]any, len(addresses))
+	for i, s := range addresses {
+		l[i] = s
+	}
+	q := bstore.QueryDB[PGPKey](ctx, a.DB)
+	q.FilterEqual("Address", l...)
+	q.FilterEqual("Own", false)
+	return q.List()
+}
+
+// autocryptUpdate updates the key for the sender of an incoming message with an
+// Autocrypt header, https://autocrypt.org/level1.html#updating-autocrypt-peer-state.
+// Keys imported manually by the user are not replaced.
+func autocryptUpdate(log mlog.Log, tx *bstore.Tx, part *message.Part, date time.Time) error {
+	if len(part.Envelope.From) != 1 {
+		return nil
+	}
+	h, err := part.Header()
+	if err != nil {
+		log.Debugx("parsing header for autocrypt", err)
+		return nil
+	}
+	values := h.Values("Autocrypt")
+	if len(values) != 1 {
+		// Zero, or multiple which are all ignored according to the specification.
+		return nil
+	}
+	from := part.Envelope.From[0]
+	fromAddr, err := smtp.ParseAddress(from.User + "@" + from.Host)
+	if err != nil {
+		return nil
+	}
+	ah, err := autocrypt.Parse(values[0])
+	if err != nil {
+		log.Debugx("parsing autocrypt header", err)
+		return nil
+	}
+	if addr, err := smtp.ParseAddress(ah.Addr); err != nil || addr != fromAddr {
+		log.Debug("autocrypt header address does not match from address", slog.String("addr", ah.Addr), slog.Any("from", fromAddr))
+		return nil
+	}
+	k, err := autocrypt.ParseKey(ah.KeyData)
+	if err != nil || k.Secret {
+		log.Debugx("parsing key in autocrypt header", err)
+		return nil
+	}
+
+	q := bstore.QueryTx[PGPKey](tx)
+	q.FilterNonzero(PGPKey{Address: fromAddr.String()})
+	q.FilterEqual("Own", false)
+	pk, err := q.Get()
+	if err == bstore.ErrAbsent {
+		pk = PGPKey{Address: fromAddr.String(), Source: "autocrypt"}
+	} else if err != nil {
+		return fmt.Errorf("get key for autocrypt: %v", err)
+	} else if pk.Source != "autocrypt" || !date.After(pk.AutocryptTimestamp) {
+		return nil
+	}
+	pk.Fingerprint = k.Fingerprint
+	pk.UserIDs = k.UserIDs
+	pk.PublicKey = autocrypt.Armor("PGP PUBLIC KEY BLOCK", ah.KeyData)
+	pk.PreferEncrypt = ah.PreferEncrypt
+	pk.AutocryptTimestamp = date
+	if pk.ID == 0 {
+		err = tx.Insert(&pk)
+	} else {
+		err = tx.Update(&pk)
+	}
+	if err != nil {
+		return fmt.Errorf("saving key from autocrypt header: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package store
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/autocrypt"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+)
+
+// testPGPKey returns a binary OpenPGP key with bogus key material, with the
+// public or secret key packet and a user id packet.
+func testPGPKey(secret bool, seed byte, uid string) []byte {
+	// Version 4, creation time, EdDSA, curve OID for Ed25519, 7-bit point.
+	pk := []byte{4, 0x5f, 0, 0, seed, 22, 9, 0x2b, 0x06, 0x01, 0x04, 0x01, 0xda, 0x47, 0x0f, 0x01, 0, 7, 0x40}
+	tag := byte(6)
+	if secret {
+		tag = 5
+		pk = append(pk, 0, 0, 7, 1) // Unencrypted, bogus secret mpi.
+	}
+	buf := append([]byte{0xc0 | tag, byte(len(pk))}, pk...)
+	buf = append(buf, 0xc0|13, byte(len(uid)))
+	return append(buf, uid...)
+}
+
+func TestPGPKeys(t *testing.T) {
+	log := mlog.New("store", nil)
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	acc, err := OpenAccount(log, "mjl")
+	tcheck(t, err, "open account")
+	defer func() {
+		err = acc.Close()
+		tcheck(t, err, "closing account")
+		acc.CheckClosed()
+	}()
+	defer Switchboard()()
+
+	pub := autocrypt.Armor("PGP PUBLIC KEY BLOCK", testPGPKey(false, 1, "<mjl@mox.example>"))
+	priv := autocrypt.Armor("PGP PRIVATE KEY BLOCK", testPGPKey(true, 1, "<mjl@mox.example>"))
+	otherPriv := autocrypt.Armor("PGP PRIVATE KEY BLOCK", testPGPKey(true, 2, "<mjl@mox.example>"))
+
+	bad := []struct{ addr, pub, priv string }{
+		{"bogus", pub, ""},
+		{"remote@other.example", "not a key", ""},
+		{"remote@other.example", priv, ""},              // Secret key as public key.
+		{"mjl@mox.example", pub, otherPriv},             // Mismatching private key.
+		{"unknown@mox.example", pub, priv},              // Not our address.
+		{"mjl@mox.example", pub, pub},                   // Public key as private key.
+		{"remote@other.example", pub[:len(pub)-30], ""}, // Truncated.
+	}
+	for _, b := range bad {
+		_, err := acc.PGPKeyImport(ctxbg, b.addr, b.pub, b.priv)
+		if !errors.Is(err, ErrPGPKeyParam) {
+			t.Fatalf("import key for %q, got err %v, expected ErrPGPKeyParam", b.addr, err)
+		}
+	}
+
+	own, err := acc.PGPKeyImport(ctxbg, "mjl@mox.example", pub, priv)
+	tcheck(t, err, "import own key")
+	tcompare(t, own.Own, true)
+	tcompare(t, own.Autocrypt, true)
+	tcompare(t, own.UserIDs, []string{"<mjl@mox.example>"})
+	err = acc.PGPKeySave(ctxbg, own.ID, false, true)
+	tcheck(t, err, "save key settings")
+	k, err := acc.PGPKeyOwn(ctxbg, "mjl@mox.example")
+	tcheck(t, err, "get own key")
+	tcompare(t, k.WKDPublish, true)
+	tcompare(t, k.Autocrypt, false)
+
+	// Keys of correspondents are learned from Autocrypt headers.
+	deliver := func(mailbox, hdr string) {
+		t.Helper()
+		msgFile, err := CreateMessageTemp(log, "pgp-test")
+		tcheck(t, err, "create temp message")
+		defer os.Remove(msgFile.Name())
+		defer msgFile.Close()
+		msg := "From: <remote@other.example>\r\nTo: <mjl@mox.example>\r\nDate: " + time.Now().Add(-time.Hour).Format(time.RFC1123Z) + "\r\n" + hdr + "Subject: test\r\n\r\ntest\r\n"
+		_, err = msgFile.Write([]byte(msg))
+		tcheck(t, err, "write message")
+		m := Message{Size: int64(len(msg))}
+		acc.WithWLock(func() {
+			err = acc.DeliverMailbox(log, mailbox, &m, msgFile)
+		})
+		tcheck(t, err, "deliver")
+	}
+	otherKey := testPGPKey(false, 3, "<remote@other.example>")
+	ah := autocrypt.Header{Addr: "remote@other.example", PreferEncrypt: true, KeyData: otherKey}
+	deliver("Junk", "Autocrypt: "+ah.String()+"\r\n")
+	l, err := acc.PGPKeysPeer(ctxbg, []string{"remote@other.example"})
+	tcheck(t, err, "list peer keys")
+	tcompare(t, len(l), 0) // Not from junk.
+
+	deliver("Inbox", "Autocrypt: "+ah.String()+"\r\n")
+	l, err = acc.PGPKeysPeer(ctxbg, []string{"remote@other.example", "unknown@mox.example"})
+	tcheck(t, err, "list peer keys")
+	tcompare(t, len(l), 1)
+	tcompare(t, l[0].Source, "autocrypt")
+	tcompare(t, l[0].PreferEncrypt, true)
+	xk, err := autocrypt.ParseKey(otherKey)
+	tcheck(t, err, "parse key")
+	tcompare(t, l[0].Fingerprint, xk.Fingerprint)
+
+	// Mismatching address is ignored.
+	ah.Addr = "mismatch@mox.example"
+	ah.KeyData = testPGPKey(false, 4, "<remote@other.example>")
+	deliver("Inbox", "Autocrypt: "+ah.String()+"\r\n")
+	l, err = acc.PGPKeysPeer(ctxbg, []string{"remote@other.example"})
+	tcheck(t, err, "list peer keys")
+	tcompare(t, l[0].Fingerprint, xk.Fingerprint)
+
+	// Bad keydata is ignored, delivery succeeds.
+	deliver("Inbox", "Autocrypt: addr=remote@other.example; keydata="+base64.StdEncoding.EncodeToString([]byte("bogus"))+"\r\n")
+
+	all, err := acc.PGPKeys(ctxbg)
+	tcheck(t, err, "list keys")
+	tcompare(t, len(all), 2)
+	tcompare(t, all[0].Own, true)
+
+	err = acc.PGPKeyRemove(ctxbg, own.ID)
+	tcheck(t, err, "remove key")
+	_, err = acc.PGPKeyOwn(ctxbg, "mjl@mox.example")
+	if err == nil {
+		t.Fatalf("own key still present after removal")
+	}
+}
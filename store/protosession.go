@@ -0,0 +1,227 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mlog"
+)
+
+// Number of ended protocol sessions we keep per account for showing history. We
+// remove the oldest when adding a new session.
+const protocolSessionsHistory = 100
+
+// ErrSessionUnknown is returned when closing a protocol session that does not
+// exist or is no longer active.
+var ErrSessionUnknown = errors.New("no such active session")
+
+// ProtocolSession is an authenticated IMAP or SMTP submission connection for an
+// account. Sessions are recorded so users and admins can see which
+// clients/devices are using an account, and close connections of a lost device.
+type ProtocolSession struct {
+	ID           int64
+	Protocol     string    `bstore:"nonzero"` // E.g. "imap", "imaps", "submission", "submissions".
+	LoginAddress string    // As used during authentication.
+	RemoteIP     string    `bstore:"nonzero"`
+	ClientID     string    // Client identification, from IMAP ID command or SMTP EHLO/HELO hostname.
+	Started      time.Time `bstore:"nonzero,default now"`
+	LastActivity time.Time `bstore:"nonzero"` // Only written to database when session ends, kept in memory while active.
+	Ended        time.Time // Zero while active, or if mox stopped while the session was active.
+	Active       bool      `bstore:"-"` // Set when listing, whether the connection is still active.
+	Closed       bool      // Whether the session was closed forcibly by user or admin.
+}
+
+type activeSession struct {
+	ps    ProtocolSession
+	close func() // Closes the connection.
+}
+
+var protocolSessions = struct {
+	sync.Mutex
+	active map[string]map[int64]*activeSession // By account name, then session ID.
+}{
+	active: map[string]map[int64]*activeSession{},
+}
+
+// ProtocolSessionStart records a new authenticated session for the account. Close
+// is called when the session is closed by a user or admin, it must close the
+// connection and should not block. The returned session ID is passed to the other
+// ProtocolSession* functions.
+func (a *Account) ProtocolSessionStart(ctx context.Context, protocol, loginAddress string, remoteIP net.IP, clientID string, close func()) (int64, error) {
+	now := time.Now()
+	ps := ProtocolSession{
+		Protocol:     protocol,
+		LoginAddress: loginAddress,
+		RemoteIP:     remoteIP.String(),
+		ClientID:     clientID,
+		Started:      now,
+		LastActivity: now,
+	}
+	err := a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		// Remove the least recently active sessions if we have too many. Sessions that
+		// were active when mox stopped have no end time, so we look at last activity.
+		n, err := bstore.QueryTx[ProtocolSession](tx).Count()
+		if err != nil {
+			return fmt.Errorf("count sessions: %v", err)
+		}
+		if n >= protocolSessionsHistory {
+			q := bstore.QueryTx[ProtocolSession](tx)
+			q.SortAsc("LastActivity")
+			var remove []ProtocolSession
+			err := q.ForEach(func(ops ProtocolSession) error {
+				if n-len(remove) < protocolSessionsHistory {
+					return bstore.StopForEach
+				}
+				if !protocolSessionActive(a.Name, ops.ID) {
+					remove = append(remove, ops)
+				}
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("listing old sessions: %v", err)
+			}
+			for _, ops := range remove {
+				if err := tx.Delete(&ops); err != nil {
+					return fmt.Errorf("removing old session: %v", err)
+				}
+			}
+		}
+		return tx.Insert(&ps)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("inserting session: %w", err)
+	}
+
+	protocolSessions.Lock()
+	defer protocolSessions.Unlock()
+	if protocolSessions.active[a.Name] == nil {
+		protocolSessions.active[a.Name] = map[int64]*activeSession{}
+	}
+	protocolSessions.active[a.Name][ps.ID] = &activeSession{ps, close}
+	return ps.ID, nil
+}
+
+func protocolSessionActive(accountName string, id int64) bool {
+	protocolSessions.Lock()
+	defer protocolSessions.Unlock()
+	return protocolSessions.active[accountName][id] != nil
+}
+
+// ProtocolSessionActivity marks the session as recently active. Only updated in
+// memory, so cheap enough to call for each command.
+func (a *Account) ProtocolSessionActivity(id int64) {
+	protocolSessions.Lock()
+	defer protocolSessions.Unlock()
+	if as := protocolSessions.active[a.Name][id]; as != nil {
+		as.ps.LastActivity = time.Now()
+	}
+}
+
+// ProtocolSessionClientID sets the client identification for an active
+// session, e.g. after an IMAP ID command.
+func (a *Account) ProtocolSessionClientID(ctx context.Context, id int64, clientID string) error {
+	protocolSessions.Lock()
+	as := protocolSessions.active[a.Name][id]
+	if as != nil {
+		as.ps.ClientID = clientID
+	}
+	protocolSessions.Unlock()
+	if as == nil {
+		return ErrSessionUnknown
+	}
+	return a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		ps := ProtocolSession{ID: id}
+		if err := tx.Get(&ps); err != nil {
+			return err
+		}
+		ps.ClientID = clientID
+		return tx.Update(&ps)
+	})
+}
+
+// ProtocolSessionEnd marks the session as ended, storing its last activity.
+func (a *Account) ProtocolSessionEnd(ctx context.Context, id int64) error {
+	protocolSessions.Lock()
+	as := protocolSessions.active[a.Name][id]
+	delete(protocolSessions.active[a.Name], id)
+	if len(protocolSessions.active[a.Name]) == 0 {
+		delete(protocolSessions.active, a.Name)
+	}
+	protocolSessions.Unlock()
+
+	return a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		ps := ProtocolSession{ID: id}
+		if err := tx.Get(&ps); err == bstore.ErrAbsent {
+			// Possibly removed because of history limit.
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if as != nil {
+			ps.LastActivity = as.ps.LastActivity
+			ps.ClientID = as.ps.ClientID
+			ps.Closed = as.ps.Closed
+		}
+		ps.Ended = time.Now()
+		return tx.Update(&ps)
+	})
+}
+
+// ProtocolSessions returns the active and recent IMAP/SMTP sessions for the
+// account. Active sessions come first, then ordered by most recent activity.
+func (a *Account) ProtocolSessions(ctx context.Context) ([]ProtocolSession, error) {
+	l, err := bstore.QueryDB[ProtocolSession](ctx, a.DB).List()
+	if err != nil {
+		return nil, err
+	}
+
+	protocolSessions.Lock()
+	for i, ps := range l {
+		if as := protocolSessions.active[a.Name][ps.ID]; as != nil {
+			l[i] = as.ps
+			l[i].Active = true
+		}
+	}
+	protocolSessions.Unlock()
+
+	sort.Slice(l, func(i, j int) bool {
+		if l[i].Active != l[j].Active {
+			return l[i].Active
+		}
+		return l[i].LastActivity.After(l[j].LastActivity)
+	})
+	return l, nil
+}
+
+// ProtocolSessionClose closes an active session, e.g. of a lost or stolen device.
+// If id is 0, all active sessions of the account are closed. Note that the client
+// can log in again with its credentials, the password must be changed to prevent
+// further access.
+func (a *Account) ProtocolSessionClose(log mlog.Log, id int64) (n int, rerr error) {
+	protocolSessions.Lock()
+	var l []*activeSession
+	for xid, as := range protocolSessions.active[a.Name] {
+		if id == 0 || xid == id {
+			as.ps.Closed = true
+			l = append(l, as)
+		}
+	}
+	protocolSessions.Unlock()
+
+	if id != 0 && len(l) == 0 {
+		return 0, ErrSessionUnknown
+	}
+	for _, as := range l {
+		log.Info("closing session", slog.String("account", a.Name), slog.Int64("sessionid", as.ps.ID), slog.String("protocol", as.ps.Protocol), slog.String("remoteip", as.ps.RemoteIP))
+		as.close()
+	}
+	return len(l), nil
+}
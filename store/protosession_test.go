@@ -0,0 +1,91 @@
+package store
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+)
+
+func TestProtocolSessions(t *testing.T) {
+	log := mlog.New("store", nil)
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	acc, err := OpenAccount(log, "mjl")
+	tcheck(t, err, "open account")
+	defer func() {
+		err = acc.Close()
+		tcheck(t, err, "closing account")
+		acc.CheckClosed()
+	}()
+
+	var closed []int
+	ip := net.ParseIP("127.0.0.1")
+	id1, err := acc.ProtocolSessionStart(ctxbg, "imaps", "mjl@mox.example", ip, "", func() { closed = append(closed, 1) })
+	tcheck(t, err, "start session")
+	id2, err := acc.ProtocolSessionStart(ctxbg, "submission", "mjl@mox.example", ip, "client.example", func() { closed = append(closed, 2) })
+	tcheck(t, err, "start session")
+
+	err = acc.ProtocolSessionClientID(ctxbg, id1, "testclient 1.0")
+	tcheck(t, err, "set client id")
+
+	l, err := acc.ProtocolSessions(ctxbg)
+	tcheck(t, err, "list sessions")
+	if len(l) != 2 || !l[0].Active || !l[1].Active {
+		t.Fatalf("got sessions %#v, expected 2 active", l)
+	}
+	for _, ps := range l {
+		if ps.ID == id1 && ps.ClientID != "testclient 1.0" {
+			t.Fatalf("got client id %q, expected testclient 1.0", ps.ClientID)
+		}
+	}
+
+	n, err := acc.ProtocolSessionClose(log, id1)
+	tcheck(t, err, "close session")
+	if n != 1 || len(closed) != 1 || closed[0] != 1 {
+		t.Fatalf("closed %d sessions, closed functions called %v, expected session 1 closed", n, closed)
+	}
+	// Connection cleanup ends the session.
+	err = acc.ProtocolSessionEnd(ctxbg, id1)
+	tcheck(t, err, "end session")
+
+	_, err = acc.ProtocolSessionClose(log, id1)
+	if !errors.Is(err, ErrSessionUnknown) {
+		t.Fatalf("closing ended session, got err %v, expected ErrSessionUnknown", err)
+	}
+
+	l, err = acc.ProtocolSessions(ctxbg)
+	tcheck(t, err, "list sessions")
+	if len(l) != 2 || l[0].ID != id2 || !l[0].Active || l[1].ID != id1 || l[1].Active || !l[1].Closed || l[1].Ended.IsZero() || l[1].ClientID != "testclient 1.0" {
+		t.Fatalf("got sessions %#v, expected active session 2 and closed session 1", l)
+	}
+
+	// Close all remaining.
+	n, err = acc.ProtocolSessionClose(log, 0)
+	tcheck(t, err, "close all sessions")
+	if n != 1 || len(closed) != 2 || closed[1] != 2 {
+		t.Fatalf("closed %d sessions, closed functions called %v, expected session 2 closed", n, closed)
+	}
+	err = acc.ProtocolSessionEnd(ctxbg, id2)
+	tcheck(t, err, "end session")
+
+	// Old sessions are removed when we reach the history limit.
+	for i := 0; i < protocolSessionsHistory+5; i++ {
+		id, err := acc.ProtocolSessionStart(ctxbg, "imap", "mjl@mox.example", ip, "", func() {})
+		tcheck(t, err, "start session")
+		err = acc.ProtocolSessionEnd(ctxbg, id)
+		tcheck(t, err, "end session")
+	}
+	n, err = bstore.QueryDB[ProtocolSession](ctxbg, acc.DB).Count()
+	tcheck(t, err, "count sessions")
+	if n != protocolSessionsHistory {
+		t.Fatalf("got %d sessions, expected %d", n, protocolSessionsHistory)
+	}
+}
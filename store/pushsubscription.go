@@ -0,0 +1,73 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/mjl-/bstore"
+)
+
+// PushSubscription is a Web Push subscription of a browser, for showing
+// notifications about new messages while webmail is not open. Subscriptions are
+// added by webmail on a device, and can be removed through the account settings.
+// Subscriptions are removed automatically when the push service reports they are
+// gone.
+type PushSubscription struct {
+	ID        int64
+	Created   time.Time `bstore:"nonzero,default now"`
+	Endpoint  string    `bstore:"nonzero,unique"` // URL at push service of browser vendor.
+	P256DH    string    `bstore:"nonzero"`        // Public key of browser for encrypting messages, base64url-encoded.
+	Auth      string    `bstore:"nonzero"`        // Authentication secret for encrypting messages, base64url-encoded.
+	Label     string    // Describes the device, e.g. browser and operating system.
+	LastPush  time.Time // Last successful push.
+	LastError string    // Error of most recent push, empty after successful push.
+}
+
+// PushSubscriptions returns all Web Push subscriptions of the account.
+func (a *Account) PushSubscriptions(ctx context.Context) ([]PushSubscription, error) {
+	return bstore.QueryDB[PushSubscription](ctx, a.DB).SortAsc("ID").List()
+}
+
+// PushSubscriptionAdd adds a subscription, replacing an existing subscription
+// for the same endpoint.
+func (a *Account) PushSubscriptionAdd(ctx context.Context, ps *PushSubscription) error {
+	return a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		q := bstore.QueryTx[PushSubscription](tx)
+		q.FilterNonzero(PushSubscription{Endpoint: ps.Endpoint})
+		if _, err := q.Delete(); err != nil {
+			return err
+		}
+		ps.ID = 0
+		return tx.Insert(ps)
+	})
+}
+
+// PushSubscriptionRemove removes a subscription by ID.
+func (a *Account) PushSubscriptionRemove(ctx context.Context, id int64) error {
+	return a.DB.Delete(ctx, &PushSubscription{ID: id})
+}
+
+// PushSubscriptionRemoveEndpoint removes the subscription for an endpoint, if any.
+func (a *Account) PushSubscriptionRemoveEndpoint(ctx context.Context, endpoint string) error {
+	q := bstore.QueryDB[PushSubscription](ctx, a.DB)
+	q.FilterNonzero(PushSubscription{Endpoint: endpoint})
+	_, err := q.Delete()
+	return err
+}
+
+// PushSubscriptionResult records the result of a push to a subscription.
+func (a *Account) PushSubscriptionResult(ctx context.Context, id int64, pushErr error) error {
+	return a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		ps := PushSubscription{ID: id}
+		if err := tx.Get(&ps); err != nil {
+			return err
+		}
+		if pushErr == nil {
+			ps.LastPush = time.Now()
+			ps.LastError = ""
+		} else {
+			ps.LastError = pushErr.Error()
+		}
+		return tx.Update(&ps)
+	})
+}
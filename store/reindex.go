@@ -0,0 +1,319 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/mlog"
+)
+
+// ReindexSteps are the steps of a reindex of an account, in order of execution.
+//
+// "parse" parses the message files again, storing the MIME structure used when
+// searching and fetching messages. "threads" resets and assigns the threads of
+// all messages. "counts" recalculates the message counts and sizes of the
+// mailboxes and the account.
+var ReindexSteps = []string{"parse", "threads", "counts"}
+
+// Reindex is the progress of a reindex of an account, for resuming a reindex that
+// was interrupted, e.g. by a restart of mox. It is removed when the reindex
+// completes.
+type Reindex struct {
+	ID      int64    // Always 1.
+	Steps   []string // Remaining steps, the first is in progress.
+	LastID  int64    // ID of the last processed message of the step in progress.
+	Started time.Time
+	Updated time.Time
+}
+
+// Reindex rebuilds the data derived from messages for the given steps, see
+// ReindexSteps. Messages are processed in batches, so the account remains
+// accessible while reindexing. Progress is written to w.
+//
+// Progress is also stored in the database. If an earlier reindex was interrupted,
+// its remaining steps are resumed instead, unless restart is set. The "parse"
+// step resumes after the last processed message, the other steps start over.
+func (a *Account) Reindex(ctx context.Context, log mlog.Log, steps []string, restart bool, w io.Writer) error {
+	for _, s := range steps {
+		if !slices.Contains(ReindexSteps, s) {
+			return fmt.Errorf("unknown reindex step %q", s)
+		}
+	}
+
+	// Don't interfere with the automatic threading upgrade.
+	if err := a.ThreadingWait(log); err != nil {
+		return fmt.Errorf("waiting for threading upgrade to finish: %v", err)
+	}
+
+	ri := Reindex{ID: 1}
+	err := a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		err := tx.Get(&ri)
+		if err == nil && !restart {
+			_, err := fmt.Fprintf(w, "resuming reindex started at %s, remaining steps %s\n", ri.Started.Format(time.RFC3339), strings.Join(ri.Steps, ","))
+			return err
+		} else if err != nil && err != bstore.ErrAbsent {
+			return err
+		}
+		exists := err == nil
+
+		now := time.Now()
+		ri = Reindex{ID: 1, Started: now, Updated: now}
+		for _, s := range ReindexSteps {
+			if slices.Contains(steps, s) {
+				ri.Steps = append(ri.Steps, s)
+			}
+		}
+		if exists {
+			return tx.Update(&ri)
+		}
+		return tx.Insert(&ri)
+	})
+	if err != nil {
+		return fmt.Errorf("storing reindex progress: %v", err)
+	}
+
+	for len(ri.Steps) > 0 {
+		step := ri.Steps[0]
+		switch step {
+		case "parse":
+			err = a.reindexParse(ctx, log, &ri, w)
+		case "threads":
+			err = a.reindexThreads(ctx, log, w)
+		case "counts":
+			err = a.RecalculateMailboxCounts(ctx, w)
+		default:
+			err = fmt.Errorf("unknown step")
+		}
+		if err != nil {
+			return fmt.Errorf("reindex step %s: %w", step, err)
+		}
+
+		ri.Steps = ri.Steps[1:]
+		ri.LastID = 0
+		ri.Updated = time.Now()
+		err := a.DB.Write(ctx, func(tx *bstore.Tx) error {
+			if len(ri.Steps) == 0 {
+				return tx.Delete(&ri)
+			}
+			return tx.Update(&ri)
+		})
+		if err != nil {
+			return fmt.Errorf("storing reindex progress: %v", err)
+		}
+		if _, err := fmt.Fprintf(w, "step %s done\n", step); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reindexParse parses messages again, starting after ri.LastID. The progress is
+// updated in the same transaction as the messages.
+func (a *Account) reindexParse(ctx context.Context, log mlog.Log, ri *Reindex, w io.Writer) error {
+	const batchSize = 100
+
+	var total int
+	err := a.DB.Read(ctx, func(tx *bstore.Tx) error {
+		q := bstore.QueryTx[Message](tx)
+		q.FilterEqual("Expunged", false)
+		var err error
+		total, err = q.Count()
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("counting messages: %v", err)
+	}
+
+	var done int
+	if ri.LastID > 0 {
+		err := a.DB.Read(ctx, func(tx *bstore.Tx) error {
+			q := bstore.QueryTx[Message](tx)
+			q.FilterEqual("Expunged", false)
+			q.FilterLessEqual("ID", ri.LastID)
+			var err error
+			done, err = q.Count()
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("counting processed messages: %v", err)
+		}
+	}
+
+	for {
+		var n int
+		// Don't process all message in one transaction, we could block the account for too long.
+		err := a.DB.Write(ctx, func(tx *bstore.Tx) error {
+			q := bstore.QueryTx[Message](tx)
+			q.FilterEqual("Expunged", false)
+			q.FilterGreater("ID", ri.LastID)
+			q.Limit(batchSize)
+			q.SortAsc("ID")
+			err := q.ForEach(func(m Message) error {
+				ri.LastID = m.ID
+				n++
+				mr := a.MessageReader(m)
+				p, err := message.EnsurePart(log.Logger, false, mr, m.Size)
+				if err != nil {
+					if _, err := fmt.Fprintf(w, "parsing message %d: %v (continuing)\n", m.ID, err); err != nil {
+						return err
+					}
+				}
+				m.Sealed.ParsedBuf, err = json.Marshal(p)
+				if err != nil {
+					return fmt.Errorf("marshal parsed message: %v", err)
+				}
+				return tx.Update(&m)
+			})
+			if err != nil {
+				return err
+			}
+			ri.Updated = time.Now()
+			return tx.Update(ri)
+		})
+		if err != nil {
+			return fmt.Errorf("updating messages with parsed mime structure: %v", err)
+		}
+		done += n
+		if n < batchSize || done%1000 == 0 {
+			if _, err := fmt.Fprintf(w, "parsing messages, progress: %d/%d\n", done, total); err != nil {
+				return err
+			}
+		}
+		if n < batchSize {
+			return nil
+		}
+	}
+}
+
+// reindexThreads resets and assigns threads for all messages.
+func (a *Account) reindexThreads(ctx context.Context, log mlog.Log, w io.Writer) error {
+	// Ideally we would do this in a single transaction, but bstore/boltdb cannot
+	// handle so many pending changes, so we set a high batchsize.
+	const batchSize = 50000
+	total, err := a.ResetThreading(ctx, log, batchSize, true)
+	if err != nil {
+		return fmt.Errorf("resetting threading fields: %v", err)
+	}
+	if _, err := fmt.Fprintf(w, "new thread base subject assigned to %d message(s), assigning threads\n", total); err != nil {
+		return err
+	}
+	return a.AssignThreads(ctx, log, nil, 0, batchSize, w)
+}
+
+// RecalculateMailboxCounts recalculates the message counts and sizes of all
+// mailboxes, and the total message size of the account, and fixes them if
+// needed. Changes are written to w and broadcast to sessions.
+func (a *Account) RecalculateMailboxCounts(ctx context.Context, w io.Writer) (rerr error) {
+	a.WithWLock(func() {
+		var changes []Change
+		rerr = a.DB.Write(ctx, func(tx *bstore.Tx) error {
+			var totalSize int64
+			err := bstore.QueryTx[Mailbox](tx).ForEach(func(mb Mailbox) error {
+				mc, err := mb.CalculateCounts(tx)
+				if err != nil {
+					return fmt.Errorf("calculating counts for mailbox %q: %w", mb.Name, err)
+				}
+				totalSize += mc.Size
+
+				if !mb.HaveCounts || mc != mb.MailboxCounts {
+					if _, err := fmt.Fprintf(w, "for %s setting new counts %s (was %s)\n", mb.Name, mc, mb.MailboxCounts); err != nil {
+						return err
+					}
+					mb.HaveCounts = true
+					mb.MailboxCounts = mc
+					if err := tx.Update(&mb); err != nil {
+						return fmt.Errorf("storing new counts for %q: %v", mb.Name, err)
+					}
+					changes = append(changes, mb.ChangeCounts())
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			du := DiskUsage{ID: 1}
+			if err := tx.Get(&du); err != nil {
+				return fmt.Errorf("get disk usage: %v", err)
+			}
+			if du.MessageSize != totalSize {
+				if _, err := fmt.Fprintf(w, "setting new total message size %d (was %d)\n", totalSize, du.MessageSize); err != nil {
+					return err
+				}
+				du.MessageSize = totalSize
+				if err := tx.Update(&du); err != nil {
+					return fmt.Errorf("update disk usage: %v", err)
+				}
+			}
+			return nil
+		})
+		if rerr == nil {
+			BroadcastChanges(a, changes)
+		}
+	})
+	return rerr
+}
+
+// ReindexStatus returns the progress of an interrupted or running reindex, or
+// false if there is none.
+func (a *Account) ReindexStatus(ctx context.Context) (Reindex, bool, error) {
+	ri := Reindex{ID: 1}
+	err := a.DB.Read(ctx, func(tx *bstore.Tx) error {
+		return tx.Get(&ri)
+	})
+	if err == bstore.ErrAbsent {
+		return ri, false, nil
+	}
+	return ri, err == nil, err
+}
+
+// UpgradeWait waits until the one-time upgrades of the account database have
+// completed, writing progress to w each interval. It returns immediately if no
+// upgrade is in progress.
+func (a *Account) UpgradeWait(ctx context.Context, w io.Writer, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.threadsCompleted:
+			return a.threadsErr
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		up := Upgrade{ID: 1}
+		var remaining int
+		err := a.DB.Read(ctx, func(tx *bstore.Tx) error {
+			if err := tx.Get(&up); err != nil {
+				return err
+			}
+			q := bstore.QueryTx[Message](tx)
+			q.FilterEqual("Expunged", false)
+			q.FilterEqual("ThreadID", int64(0))
+			var err error
+			remaining, err = q.Count()
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("reading upgrade progress: %v", err)
+		}
+		var line string
+		if up.Threads == 0 {
+			line = "threads upgrade, step 1/2: adding message-id and base subject to messages"
+		} else {
+			line = fmt.Sprintf("threads upgrade, step 2/2: assigning threads, %d messages remaining", remaining)
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package store
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mox-"
+)
+
+func TestReindex(t *testing.T) {
+	log := pkglog
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	acc, err := OpenAccount(log, "mjl")
+	tcheck(t, err, "open account")
+	defer func() {
+		err = acc.Close()
+		tcheck(t, err, "closing account")
+		acc.CheckClosed()
+	}()
+	defer Switchboard()()
+
+	var msgs []Message
+	for _, msg := range []string{
+		"Message-Id: <m0@localhost>\r\nSubject: hi\r\n\r\nbody\r\n",
+		"Message-Id: <m1@localhost>\r\nIn-Reply-To: <m0@localhost>\r\nSubject: Re: hi\r\n\r\nreply\r\n",
+	} {
+		msgFile, err := CreateMessageTemp(log, "reindex-test")
+		tcheck(t, err, "create temp message")
+		defer os.Remove(msgFile.Name())
+		defer msgFile.Close()
+		_, err = msgFile.Write([]byte(msg))
+		tcheck(t, err, "write message")
+		m := Message{Received: time.Now(), Size: int64(len(msg))}
+		acc.WithWLock(func() {
+			err = acc.DeliverMailbox(log, "Inbox", &m, msgFile)
+		})
+		tcheck(t, err, "deliver message")
+		msgs = append(msgs, m)
+	}
+
+	// Break the derived data.
+	err = acc.DB.Write(ctxbg, func(tx *bstore.Tx) error {
+		for _, m := range msgs {
+			m.Sealed.ParsedBuf = nil
+			if err := tx.Update(&m); err != nil {
+				return err
+			}
+		}
+		mb, err := bstore.QueryTx[Mailbox](tx).FilterNonzero(Mailbox{Name: "Inbox"}).Get()
+		if err != nil {
+			return err
+		}
+		mb.Total = 0
+		return tx.Update(&mb)
+	})
+	tcheck(t, err, "breaking derived data")
+
+	var out strings.Builder
+	err = acc.Reindex(ctxbg, log, ReindexSteps, false, &out)
+	tcheck(t, err, "reindex")
+	err = acc.CheckConsistency()
+	tcheck(t, err, "check consistency")
+	err = acc.DB.Read(ctxbg, func(tx *bstore.Tx) error {
+		m := Message{ID: msgs[1].ID}
+		if err := tx.Get(&m); err != nil {
+			return err
+		}
+		if len(m.Sealed.ParsedBuf) == 0 || m.ThreadID != msgs[0].ID {
+			t.Fatalf("message not reindexed, threadid %d", m.ThreadID)
+		}
+		return nil
+	})
+	tcheck(t, err, "get message")
+	_, ok, err := acc.ReindexStatus(ctxbg)
+	tcheck(t, err, "reindex status")
+	tcompare(t, ok, false)
+
+	// Resume an interrupted reindex, after the first message.
+	err = acc.DB.Insert(ctxbg, &Reindex{ID: 1, Steps: []string{"parse", "counts"}, LastID: msgs[0].ID})
+	tcheck(t, err, "insert reindex progress")
+	out.Reset()
+	err = acc.Reindex(ctxbg, log, []string{"threads"}, false, &out)
+	tcheck(t, err, "resume reindex")
+	for _, s := range []string{"resuming reindex", "progress: 2/2", "step parse done", "step counts done"} {
+		if !strings.Contains(out.String(), s) {
+			t.Fatalf("missing %q in output %q", s, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "threads") {
+		t.Fatalf("unexpected threads step in resumed reindex: %q", out.String())
+	}
+
+	err = acc.Reindex(ctxbg, log, []string{"bogus"}, false, io.Discard)
+	if err == nil {
+		t.Fatalf("expected error for unknown step")
+	}
+
+	err = acc.UpgradeWait(ctxbg, io.Discard, time.Second)
+	tcheck(t, err, "upgrade wait")
+}
@@ -0,0 +1,195 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/message"
+)
+
+// ErrRetentionRuleParam is returned when saving a retention rule with invalid
+// parameters.
+var ErrRetentionRuleParam = errors.New("invalid retention rule parameter")
+
+// RetentionAction is the action of a retention rule for old messages.
+type RetentionAction string
+
+const (
+	RetentionArchive     RetentionAction = "archive"     // Move to Destination mailbox.
+	RetentionArchiveYear RetentionAction = "archiveyear" // Move to a child mailbox of Destination named after the year the message was received, e.g. Archive/2024.
+	RetentionDelete      RetentionAction = "delete"      // Remove message permanently.
+)
+
+// RetentionRule is a rule for messages in a mailbox that are older than a number
+// of days, e.g. for moving messages from Inbox to an archive mailbox, or for
+// removing messages from Trash and Junk. Rules are managed by the user in webmail,
+// and applied periodically in the background. A rule in dry-run mode only
+// records the number of matching messages.
+type RetentionRule struct {
+	ID          int64
+	Mailbox     string // Mailbox the rule applies to, not including child mailboxes.
+	AgeDays     int    // Rule applies to messages received at least this many days ago.
+	Action      RetentionAction
+	Destination string // Mailbox for the archive actions. Created if it does not exist.
+	Disabled    bool
+	DryRun      bool // Only report the number of matching messages, don't change anything.
+
+	// Optional conditions. Case-insensitive substring matches, like FilterRule.
+	From        string // Name or address in From header.
+	Subject     string
+	KeepFlagged bool // Don't apply rule to flagged messages.
+	KeepUnread  bool // Don't apply rule to unread messages.
+
+	// Result of most recent run in the background.
+	LastRun     time.Time
+	LastMatched int    // Number of messages matched, and moved or removed if not DryRun.
+	LastError   string // Empty if the last run was successful.
+}
+
+// check validates and normalizes the rule.
+func (r *RetentionRule) check() error {
+	r.Mailbox = strings.TrimSpace(r.Mailbox)
+	r.Destination = strings.TrimSpace(r.Destination)
+
+	name, _, err := CheckMailboxName(r.Mailbox, true)
+	if err != nil {
+		return fmt.Errorf("%w: mailbox: %v", ErrRetentionRuleParam, err)
+	}
+	r.Mailbox = name
+	if r.AgeDays <= 0 {
+		return fmt.Errorf("%w: age in days must be at least 1", ErrRetentionRuleParam)
+	}
+	switch r.Action {
+	case RetentionArchive, RetentionArchiveYear:
+		name, _, err := CheckMailboxName(r.Destination, true)
+		if err != nil {
+			return fmt.Errorf("%w: destination mailbox: %v", ErrRetentionRuleParam, err)
+		}
+		r.Destination = name
+		if r.Destination == r.Mailbox {
+			return fmt.Errorf("%w: destination mailbox must be different from mailbox", ErrRetentionRuleParam)
+		}
+	case RetentionDelete:
+		if r.Destination != "" {
+			return fmt.Errorf("%w: destination mailbox not allowed for delete action", ErrRetentionRuleParam)
+		}
+	default:
+		return fmt.Errorf("%w: unknown action %q", ErrRetentionRuleParam, r.Action)
+	}
+	return nil
+}
+
+// NeedsEnvelope returns whether the rule has conditions on the message envelope.
+func (r RetentionRule) NeedsEnvelope() bool {
+	return r.From != "" || r.Subject != ""
+}
+
+// Match returns whether the rule matches the message, received before cutoff.
+// The envelope is only used if NeedsEnvelope returns true.
+func (r RetentionRule) Match(m Message, env *message.Envelope, cutoff time.Time) bool {
+	if m.Expunged || !m.Received.Before(cutoff) || r.KeepFlagged && m.Flagged || r.KeepUnread && !m.Seen {
+		return false
+	}
+	if !r.NeedsEnvelope() {
+		return true
+	}
+	if env == nil {
+		return false
+	}
+	contains := func(s, sub string) bool {
+		return strings.Contains(strings.ToLower(s), strings.ToLower(sub))
+	}
+	if r.From != "" {
+		var found bool
+		for _, a := range env.From {
+			if contains(a.Name+" <"+a.User+"@"+a.Host+">", r.From) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return r.Subject == "" || contains(env.Subject, r.Subject)
+}
+
+// Cutoff returns the time before which messages must have been received to match
+// the rule.
+func (r RetentionRule) Cutoff(now time.Time) time.Time {
+	return now.AddDate(0, 0, -r.AgeDays)
+}
+
+// DestinationMailbox returns the name of the mailbox a message matched by an
+// archive rule is moved to.
+func (r RetentionRule) DestinationMailbox(m Message) string {
+	if r.Action == RetentionArchiveYear {
+		return fmt.Sprintf("%s/%d", r.Destination, m.Received.Year())
+	}
+	return r.Destination
+}
+
+// RetentionRules returns the retention rules of the account.
+func (a *Account) RetentionRules(ctx context.Context) ([]RetentionRule, error) {
+	return bstore.QueryDB[RetentionRule](ctx, a.DB).SortAsc("Mailbox", "ID").List()
+}
+
+// CheckRetentionRule validates and normalizes a rule without storing it, e.g. for
+// a dry run of a new rule.
+func CheckRetentionRule(r RetentionRule) (RetentionRule, error) {
+	err := r.check()
+	return r, err
+}
+
+// RetentionRuleSave validates and stores a retention rule. If r.ID is 0, a new
+// rule is added, otherwise the existing rule is updated, keeping the results of
+// its last run.
+func (a *Account) RetentionRuleSave(ctx context.Context, r RetentionRule) (RetentionRule, error) {
+	if err := r.check(); err != nil {
+		return RetentionRule{}, err
+	}
+	err := a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		if r.ID == 0 {
+			r.LastRun = time.Time{}
+			r.LastMatched = 0
+			r.LastError = ""
+			return tx.Insert(&r)
+		}
+		or := RetentionRule{ID: r.ID}
+		if err := tx.Get(&or); err != nil {
+			return err
+		}
+		r.LastRun = or.LastRun
+		r.LastMatched = or.LastMatched
+		r.LastError = or.LastError
+		return tx.Update(&r)
+	})
+	return r, err
+}
+
+// RetentionRuleRemove removes a retention rule.
+func (a *Account) RetentionRuleRemove(ctx context.Context, id int64) error {
+	return a.DB.Delete(ctx, &RetentionRule{ID: id})
+}
+
+// RetentionRuleResult records the result of running a rule in the background.
+func (a *Account) RetentionRuleResult(ctx context.Context, id int64, matched int, runErr error) error {
+	return a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		r := RetentionRule{ID: id}
+		if err := tx.Get(&r); err != nil {
+			return err
+		}
+		r.LastRun = time.Now()
+		r.LastMatched = matched
+		r.LastError = ""
+		if runErr != nil {
+			r.LastError = runErr.Error()
+		}
+		return tx.Update(&r)
+	})
+}
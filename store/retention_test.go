@@ -0,0 +1,41 @@
+package store
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/message"
+)
+
+func TestRetentionRules(t *testing.T) {
+	bad := []RetentionRule{
+		{Mailbox: "Inbox", Action: RetentionDelete},                                        // No age.
+		{Mailbox: "Inbox", AgeDays: 1, Action: "bogus"},                                    // Bad action.
+		{Mailbox: "Inbox", AgeDays: 1, Action: RetentionArchive},                           // No destination.
+		{Mailbox: "Inbox", AgeDays: 1, Action: RetentionArchive, Destination: "Inbox"},     // Same mailbox.
+		{Mailbox: "Trash", AgeDays: 1, Action: RetentionDelete, Destination: "Archive"},    // Destination with delete.
+		{Mailbox: "Inbox/", AgeDays: 1, Action: RetentionArchiveYear, Destination: "Arch"}, // Bad mailbox name.
+	}
+	for _, r := range bad {
+		_, err := CheckRetentionRule(r)
+		if !errors.Is(err, ErrRetentionRuleParam) {
+			t.Fatalf("checking rule %#v, got err %v, expected ErrRetentionRuleParam", r, err)
+		}
+	}
+
+	r, err := CheckRetentionRule(RetentionRule{Mailbox: " inbox ", AgeDays: 30, Action: RetentionArchiveYear, Destination: "Archive", From: "EXAMPLE.org", KeepFlagged: true})
+	tcheck(t, err, "check rule")
+	tcompare(t, r.Mailbox, "Inbox")
+
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	cutoff := r.Cutoff(now)
+	old := Message{Received: now.AddDate(-1, 0, 0)}
+	env := &message.Envelope{From: []message.Address{{Name: "Remote", User: "remote", Host: "example.org"}}}
+	tcompare(t, r.Match(old, env, cutoff), true)
+	tcompare(t, r.Match(old, nil, cutoff), false)
+	tcompare(t, r.Match(Message{Received: now.AddDate(0, 0, -1)}, env, cutoff), false)
+	tcompare(t, r.Match(Message{Received: old.Received, Flags: Flags{Flagged: true}}, env, cutoff), false)
+	tcompare(t, r.Match(old, &message.Envelope{From: []message.Address{{User: "other", Host: "example.com"}}}, cutoff), false)
+	tcompare(t, r.DestinationMailbox(old), "Archive/2024")
+}
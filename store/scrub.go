@@ -0,0 +1,506 @@
+package store
+
+// Scrubbing of message files.
+//
+// When a message is delivered, the SHA-256 checksum of its message file is stored
+// with the message in the database. The scrubber periodically reads all message
+// files at a limited rate, and compares their contents with the checksum, to
+// detect corruption on disk that would otherwise go unnoticed until a message is
+// read, possibly years later. Messages delivered before checksums were stored get
+// their checksum on their first scrub. Corrupt message files can be restored from
+// copies of the data directory, e.g. backups or the data directory of a standby.
+//
+// Progress is kept in a state file in the data directory, so a scrub interrupted
+// by a restart continues where it left off.
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+)
+
+var (
+	metricScrubMessages = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "mox_store_scrub_messages_total",
+			Help: "Total message files checked by the scrubber.",
+		},
+	)
+	metricScrubCorrupt = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "mox_store_scrub_corrupt_total",
+			Help: "Total message files found corrupt or missing by the scrubber, including those restored.",
+		},
+	)
+	metricScrubRestored = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "mox_store_scrub_restored_total",
+			Help: "Total corrupt message files restored by the scrubber from a copy of the data directory.",
+		},
+	)
+)
+
+// ScrubStateFile is the file in the data directory with the progress of the
+// scrubber.
+const ScrubStateFile = "scrub.json"
+
+// ScrubOptions are the parameters for scrubbing an account.
+type ScrubOptions struct {
+	StartID        int64    // Only messages with a higher ID are checked.
+	BytesPerSecond int64    // If > 0, reading of message files is limited to this rate.
+	RestoreFrom    []string // Directories with copies of the data directory.
+
+	// If set, called after each batch of messages, with the ID of the last checked
+	// message.
+	Progress func(lastID int64)
+}
+
+// ScrubProblem is a message file that is missing, cannot be read, or does not
+// match its checksum.
+type ScrubProblem struct {
+	MailboxID int64
+	MessageID int64
+	Path      string
+	Text      string
+	Restored  string // Path of the copy the file was restored from, if any.
+}
+
+// ScrubResult is the outcome of scrubbing an account.
+type ScrubResult struct {
+	Messages    int   // Message files checked.
+	Size        int64 // Bytes read.
+	Checksummed int   // Messages that got their first checksum.
+	Problems    []ScrubProblem
+}
+
+// messageFileChecksum returns the SHA-256 of the contents of a message file,
+// decrypted with key if encrypted is set, and decompressed if compressed is set,
+// along with the size of the contents.
+func messageFileChecksum(path string, key []byte, encrypted, compressed bool) ([]byte, int64, error) {
+	mf, size, err := openMessageFile(path, key, encrypted, compressed)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer mf.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(mf, 0, size)); err != nil {
+		return nil, 0, err
+	}
+	return h.Sum(nil), size, nil
+}
+
+// checkMessageFile returns a description of the problem with the message file of
+// m, or an empty string if the file is fine. If m has no checksum yet, the
+// checksum of the file is returned.
+func (a *Account) checkMessageFile(m Message) (problem string, sum []byte, size int64) {
+	sum, size, err := messageFileChecksum(a.MessagePath(m.ID), messageKey(a.Name), m.FileEncrypted, m.FileCompressed)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "message file is missing", nil, 0
+		}
+		return fmt.Sprintf("reading message file: %v", err), nil, size
+	} else if int64(len(m.Sealed.MsgPrefix))+size != m.Size {
+		return fmt.Sprintf("message size %d does not match size %d in database", int64(len(m.Sealed.MsgPrefix))+size, m.Size), nil, size
+	} else if m.Checksum != nil && !bytes.Equal(sum, m.Checksum) {
+		return "contents do not match checksum", nil, size
+	}
+	return "", sum, size
+}
+
+// Scrub reads the message files of the account, and compares their contents with
+// their checksum. Messages without checksum get the checksum of their file. Files
+// that are missing, cannot be read, or don't match their checksum or size are
+// returned as problems. If a copy of the message file in one of the RestoreFrom
+// directories is intact, it replaces the corrupt file.
+//
+// Message files are read without holding the account lock. Problems are checked
+// again, and checksums stored, with the account write lock held. Messages are
+// processed in batches, opts.Progress is called after each batch.
+func (a *Account) Scrub(ctx context.Context, log mlog.Log, opts ScrubOptions) (ScrubResult, error) {
+	const batchSize = 100
+
+	var result ScrubResult
+	lastID := opts.StartID
+	for {
+		var msgs []Message
+		var err error
+		a.WithRLock(func() {
+			q := bstore.QueryDB[Message](ctx, a.DB)
+			q.FilterGreater("ID", lastID)
+			q.FilterEqual("Expunged", false)
+			q.SortAsc("ID")
+			q.Limit(batchSize)
+			msgs, err = q.List()
+		})
+		if err != nil {
+			return result, fmt.Errorf("listing messages: %v", err)
+		} else if len(msgs) == 0 {
+			return result, nil
+		}
+
+		sums := map[int64][]byte{}
+		var bad []Message
+		for _, m := range msgs {
+			problem, sum, size := a.checkMessageFile(m)
+			result.Messages++
+			result.Size += size
+			metricScrubMessages.Inc()
+			if problem != "" {
+				bad = append(bad, m)
+			} else if m.Checksum == nil {
+				sums[m.ID] = sum
+			}
+
+			if opts.BytesPerSecond > 0 && size > 0 {
+				select {
+				case <-ctx.Done():
+				case <-time.After(time.Duration(size * int64(time.Second) / opts.BytesPerSecond)):
+				}
+			}
+			if err := ctx.Err(); err != nil {
+				return result, err
+			}
+		}
+
+		if len(sums) > 0 || len(bad) > 0 {
+			a.WithWLock(func() {
+				err = a.DB.Write(ctx, func(tx *bstore.Tx) error {
+					for id, sum := range sums {
+						m := Message{ID: id}
+						if err := tx.Get(&m); err == bstore.ErrAbsent || err == nil && (m.Expunged || m.Checksum != nil) {
+							continue
+						} else if err != nil {
+							return fmt.Errorf("get message: %v", err)
+						}
+						m.Checksum = sum
+						if err := tx.Update(&m); err != nil {
+							return fmt.Errorf("update message: %v", err)
+						}
+						result.Checksummed++
+					}
+
+					for _, bm := range bad {
+						// The message may have been removed or changed in the meantime, check again.
+						m := Message{ID: bm.ID}
+						if err := tx.Get(&m); err == bstore.ErrAbsent || err == nil && m.Expunged {
+							continue
+						} else if err != nil {
+							return fmt.Errorf("get message: %v", err)
+						}
+						problem, _, _ := a.checkMessageFile(m)
+						if problem == "" {
+							continue
+						}
+						metricScrubCorrupt.Inc()
+						p := a.MessagePath(m.ID)
+						pr := ScrubProblem{m.MailboxID, m.ID, p, problem, ""}
+						pr.Restored = a.scrubRestore(log, m, opts.RestoreFrom)
+						if pr.Restored != "" {
+							metricScrubRestored.Inc()
+							log.Info("restored corrupt message file", slog.String("account", a.Name), slog.Int64("msgid", m.ID), slog.String("problem", problem), slog.String("from", pr.Restored))
+						} else {
+							log.Error("corrupt message file", slog.String("account", a.Name), slog.Int64("msgid", m.ID), slog.String("path", p), slog.String("problem", problem))
+						}
+						result.Problems = append(result.Problems, pr)
+					}
+					return nil
+				})
+			})
+			if err != nil {
+				return result, err
+			}
+		}
+
+		lastID = msgs[len(msgs)-1].ID
+		if opts.Progress != nil {
+			opts.Progress(lastID)
+		}
+	}
+}
+
+// scrubRestore tries to replace the message file of m with an intact copy from
+// one of the directories. The path of the copy is returned, or an empty string if
+// no intact copy was found. Must be called with the account write lock held.
+func (a *Account) scrubRestore(log mlog.Log, m Message, dirs []string) string {
+	key := messageKey(a.Name)
+	p := a.MessagePath(m.ID)
+	for _, dir := range dirs {
+		cp := filepath.Join(mox.ConfigDirPath(dir), "accounts", a.Name, "msg", MessagePath(m.ID))
+		sum, size, err := messageFileChecksum(cp, key, m.FileEncrypted, m.FileCompressed)
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				log.Infox("checking copy of message file for restore", err, slog.String("path", cp))
+			}
+			continue
+		} else if int64(len(m.Sealed.MsgPrefix))+size != m.Size || m.Checksum != nil && !bytes.Equal(sum, m.Checksum) {
+			log.Info("copy of message file does not match, not restoring from it", slog.String("path", cp))
+			continue
+		}
+
+		// Copy to temporary file next to the message file, and replace it atomically. We
+		// don't hard link, the copy must stay independent of the restored file.
+		tmpPath := p + ".scrub"
+		os.Remove(tmpPath)
+		os.MkdirAll(filepath.Dir(p), 0770)
+		err = copyFile(cp, tmpPath)
+		if err == nil {
+			err = os.Rename(tmpPath, p)
+		}
+		if err != nil {
+			xerr := os.Remove(tmpPath)
+			log.Check(xerr, "removing temporary restored message file")
+			log.Errorx("restoring message file", err, slog.String("path", p), slog.String("from", cp))
+			continue
+		}
+		return cp
+	}
+	return ""
+}
+
+// copyFile copies the file at src to a new file dst, and syncs it.
+func copyFile(src, dst string) (rerr error) {
+	sf, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer sf.Close()
+	df, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0660)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if df != nil {
+			df.Close()
+		}
+		if rerr != nil {
+			os.Remove(dst)
+		}
+	}()
+	if _, err := io.Copy(df, sf); err != nil {
+		return err
+	}
+	if err := df.Sync(); err != nil {
+		return fmt.Errorf("sync: %v", err)
+	}
+	err = df.Close()
+	df = nil
+	return err
+}
+
+// scrubState is the progress of the scrubber, stored in the data directory.
+type scrubState struct {
+	Started   time.Time        // Of the current or last scrub.
+	Completed time.Time        // Zero while the scrub is in progress.
+	Accounts  map[string]int64 // ID of last checked message per account, -1 if the account is done.
+}
+
+func readScrubState() (scrubState, error) {
+	var st scrubState
+	buf, err := os.ReadFile(mox.DataDirPath(ScrubStateFile))
+	if err != nil && errors.Is(err, fs.ErrNotExist) {
+		return st, nil
+	} else if err != nil {
+		return st, err
+	}
+	err = json.Unmarshal(buf, &st)
+	return st, err
+}
+
+func writeScrubState(st scrubState) error {
+	buf, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	p := mox.DataDirPath(ScrubStateFile)
+	if err := os.WriteFile(p+".tmp", buf, 0660); err != nil {
+		return err
+	}
+	return os.Rename(p+".tmp", p)
+}
+
+// StartScrub starts the background scrubber, which checks the message files of
+// all accounts once every configured interval.
+func StartScrub() {
+	log := mlog.New("store", nil)
+
+	go func() {
+		defer func() {
+			x := recover()
+			if x != nil {
+				log.Error("recover from panic", slog.Any("panic", x))
+				debug.PrintStack()
+				metrics.PanicInc(metrics.Store)
+			}
+		}()
+
+		conf := *mox.Conf.Static.Scrub
+		if conf.Interval == 0 {
+			conf.Interval = 7 * 24 * time.Hour
+		}
+		if conf.BytesPerSecond == 0 {
+			conf.BytesPerSecond = 10 * 1024 * 1024
+		}
+
+		for {
+			st, err := readScrubState()
+			if err != nil {
+				log.Errorx("reading scrub state, starting new scrub", err)
+				st = scrubState{}
+			}
+			if st.Started.IsZero() || !st.Completed.IsZero() {
+				select {
+				case <-mox.Shutdown.Done():
+					return
+				case <-time.After(time.Until(st.Started.Add(conf.Interval))):
+				}
+				st = scrubState{Started: time.Now(), Accounts: map[string]int64{}}
+			}
+			if st.Accounts == nil {
+				st.Accounts = map[string]int64{}
+			}
+
+			if !scrubAccounts(log, conf, &st) {
+				return
+			}
+			st.Completed = time.Now()
+			err = writeScrubState(st)
+			log.Check(err, "writing scrub state")
+		}
+	}()
+}
+
+// scrubAccounts scrubs the accounts that have not been done yet in the scrub
+// of st. Returns false if mox is shutting down.
+func scrubAccounts(log mlog.Log, conf config.Scrub, st *scrubState) bool {
+	accounts := mox.Conf.Accounts()
+	slices.Sort(accounts)
+	for _, accName := range accounts {
+		if st.Accounts[accName] < 0 {
+			continue
+		}
+		opts := ScrubOptions{
+			StartID:        st.Accounts[accName],
+			BytesPerSecond: conf.BytesPerSecond,
+			RestoreFrom:    conf.RestoreFrom,
+			Progress: func(lastID int64) {
+				st.Accounts[accName] = lastID
+				err := writeScrubState(*st)
+				log.Check(err, "writing scrub state")
+			},
+		}
+		result, err := scrubAccount(log, accName, opts)
+		if mox.Shutdown.Err() != nil {
+			return false
+		}
+		if err != nil {
+			log.Errorx("scrubbing account", err, slog.String("account", accName))
+		} else {
+			log.Info("scrubbed account",
+				slog.String("account", accName),
+				slog.Int("messages", result.Messages),
+				slog.Int64("size", result.Size),
+				slog.Int("checksummed", result.Checksummed),
+				slog.Int("problems", len(result.Problems)))
+		}
+		if len(result.Problems) > 0 {
+			err := scrubNotify(log, accName, result.Problems)
+			log.Check(err, "notifying postmaster about corrupt message files")
+		}
+		st.Accounts[accName] = -1
+		err = writeScrubState(*st)
+		log.Check(err, "writing scrub state")
+	}
+	return true
+}
+
+func scrubAccount(log mlog.Log, accName string, opts ScrubOptions) (ScrubResult, error) {
+	acc, err := OpenAccount(log, accName)
+	if err != nil {
+		return ScrubResult{}, err
+	}
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account after scrub")
+	}()
+	return acc.Scrub(mox.Shutdown, log, opts)
+}
+
+// scrubNotify delivers a message about corrupt message files to the postmaster.
+func scrubNotify(log mlog.Log, accName string, problems []ScrubProblem) error {
+	var b strings.Builder
+	var unrestored int
+	for _, pr := range problems {
+		fmt.Fprintf(&b, "- message %d in mailbox %d, %s: %s", pr.MessageID, pr.MailboxID, pr.Path, pr.Text)
+		if pr.Restored != "" {
+			fmt.Fprintf(&b, ", restored from %s", pr.Restored)
+		} else {
+			unrestored++
+		}
+		b.WriteString("\n")
+	}
+	text := fmt.Sprintf(`Hi!
+
+The scrubber found %d corrupt or missing message file(s) in account %s, of
+which %d could not be restored:
+
+%s
+Message files that were not restored can be restored from a backup, e.g. with
+"mox restore", or removed with "mox fsck -fix". Corruption of files on disk
+can be a sign of a failing disk.
+
+Cheers,
+mox
+`, len(problems), accName, unrestored, b.String())
+
+	a, err := OpenAccount(log, mox.Conf.Static.Postmaster.Account)
+	if err != nil {
+		return fmt.Errorf("open postmaster account: %v", err)
+	}
+	defer func() {
+		err := a.Close()
+		log.Check(err, "closing account")
+	}()
+	f, err := CreateMessageTemp(log, "scrub")
+	if err != nil {
+		return fmt.Errorf("creating temporary message file: %v", err)
+	}
+	defer CloseRemoveTempFile(log, f, "message for scrub notification")
+
+	m := Message{
+		Received: time.Now(),
+		Flags:    Flags{Flagged: true},
+	}
+	n, err := fmt.Fprintf(f, "Date: %s\r\nSubject: mox: corrupt message files in account %s\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: 8-bit\r\n\r\n%s", time.Now().Format(message.RFC5322Z), accName, strings.ReplaceAll(text, "\n", "\r\n"))
+	if err != nil {
+		return fmt.Errorf("writing temporary message file: %v", err)
+	}
+	m.Size = int64(n)
+
+	a.WithWLock(func() {
+		err = a.DeliverMailbox(log, mox.Conf.Static.Postmaster.Mailbox, &m, f)
+	})
+	return err
+}
@@ -0,0 +1,117 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mox-"
+)
+
+func TestScrub(t *testing.T) {
+	log := pkglog
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	acc, err := OpenAccount(log, "mjl")
+	tcheck(t, err, "open account")
+	defer func() {
+		err = acc.Close()
+		tcheck(t, err, "closing account")
+		acc.CheckClosed()
+	}()
+	defer Switchboard()()
+
+	const msg = "Subject: scrub\r\n\r\ntest\r\n"
+	deliver := func() Message {
+		t.Helper()
+		msgFile, err := CreateMessageTemp(log, "scrub-test")
+		tcheck(t, err, "create temp message")
+		defer os.Remove(msgFile.Name())
+		defer msgFile.Close()
+		_, err = msgFile.Write([]byte(msg))
+		tcheck(t, err, "write message")
+		m := Message{Received: time.Now(), Size: int64(len(msg))}
+		acc.WithWLock(func() {
+			err = acc.DeliverMailbox(log, "Inbox", &m, msgFile)
+		})
+		tcheck(t, err, "deliver message")
+		if len(m.Checksum) != 32 {
+			t.Fatalf("no checksum for delivered message")
+		}
+		return m
+	}
+	m0 := deliver()
+	m1 := deliver()
+	m2 := deliver()
+
+	// Message delivered before checksums were stored.
+	err = acc.DB.Write(ctxbg, func(tx *bstore.Tx) error {
+		m := Message{ID: m0.ID}
+		if err := tx.Get(&m); err != nil {
+			return err
+		}
+		m.Checksum = nil
+		return tx.Update(&m)
+	})
+	tcheck(t, err, "clearing checksum")
+
+	var progress int64
+	result, err := acc.Scrub(ctxbg, log, ScrubOptions{Progress: func(lastID int64) { progress = lastID }})
+	tcheck(t, err, "scrub")
+	tcompare(t, result.Messages, 3)
+	tcompare(t, result.Checksummed, 1)
+	tcompare(t, len(result.Problems), 0)
+	tcompare(t, progress, m2.ID)
+
+	m := Message{ID: m0.ID}
+	err = acc.DB.Get(ctxbg, &m)
+	tcheck(t, err, "get message")
+	tcompare(t, m.Checksum, m1.Checksum)
+
+	// Keep an intact copy of m1 in a directory with the layout of the data directory.
+	copyDir := t.TempDir()
+	cp := filepath.Join(copyDir, "accounts", "mjl", "msg", MessagePath(m1.ID))
+	os.MkdirAll(filepath.Dir(cp), 0770)
+	err = copyFile(acc.MessagePath(m1.ID), cp)
+	tcheck(t, err, "copy message file")
+
+	// Flip bits in m1, keeping the size. Remove the file of m2.
+	err = os.WriteFile(acc.MessagePath(m1.ID), []byte("Subject: scrub\r\n\r\ntesu\r\n"), 0660)
+	tcheck(t, err, "corrupt message file")
+	err = os.Remove(acc.MessagePath(m2.ID))
+	tcheck(t, err, "remove message file")
+
+	// Only checks from the start ID.
+	result, err = acc.Scrub(ctxbg, log, ScrubOptions{StartID: m1.ID})
+	tcheck(t, err, "scrub")
+	tcompare(t, result.Messages, 1)
+	tcompare(t, len(result.Problems), 1)
+	tcompare(t, result.Problems[0].MessageID, m2.ID)
+
+	result, err = acc.Scrub(ctxbg, log, ScrubOptions{RestoreFrom: []string{copyDir}})
+	tcheck(t, err, "scrub")
+	tcompare(t, len(result.Problems), 2)
+	tcompare(t, result.Problems[0].MessageID, m1.ID)
+	tcompare(t, result.Problems[0].Restored, cp)
+	tcompare(t, result.Problems[1].Restored, "")
+
+	buf, err := os.ReadFile(acc.MessagePath(m1.ID))
+	tcheck(t, err, "read restored message file")
+	tcompare(t, string(buf), msg)
+
+	// Make the account consistent again for the checks when closing.
+	err = os.WriteFile(acc.MessagePath(m2.ID), []byte(msg), 0660)
+	tcheck(t, err, "write message file")
+
+	err = scrubNotify(log, "mjl", result.Problems)
+	tcheck(t, err, "notify postmaster")
+	mb, err := bstore.QueryDB[Mailbox](ctxbg, acc.DB).FilterNonzero(Mailbox{Name: "postmaster"}).Get()
+	tcheck(t, err, "get postmaster mailbox")
+	n, err := bstore.QueryDB[Message](ctxbg, acc.DB).FilterNonzero(Message{MailboxID: mb.ID}).Count()
+	tcheck(t, err, "count postmaster messages")
+	tcompare(t, n, 1)
+}
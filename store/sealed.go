@@ -0,0 +1,386 @@
+package store
+
+// Encryption at rest of the message index in the account database.
+//
+// Fields of messages with headers and addresses are kept in Message.Sealed. When
+// encryption at rest is configured, MessageSealed is stored encrypted with
+// AES-256-GCM, with a key derived from the master key and the account name, like
+// the keys for message files. The nonce is derived from the plaintext with a
+// separate key (a synthetic IV), so equal values result in equal ciphertext: bstore
+// compares the stored form to find changed records. Without encryption key,
+// MessageSealed is stored in plain text, with a different marker.
+//
+// Message-IDs and base subjects are needed for finding the messages of a thread,
+// they are stored in indexed fields Message.MessageID and Message.SubjectBase.
+// With encryption at rest, they hold keyed hashes instead, see ThreadKey. They can
+// still be matched, but not read. Likewise, the SMTP MAIL FROM and message From
+// addresses, needed for reputation, are stored as keyed hashes in indexed fields,
+// see AddressKey.
+//
+// Messages stored before MessageSealed existed, and messages stored before
+// encryption at rest was configured, are upgraded when opening the account, see
+// UpgradeSealed.
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/smtp"
+)
+
+// First byte of stored MessageSealed.
+const (
+	sealedPlain     byte = 0
+	sealedEncrypted byte = 1
+)
+
+// ErrNoIndexKey is returned when reading an encrypted message index while no
+// encryption key is configured.
+var ErrNoIndexKey = errors.New("message index is encrypted but no encryption key is configured")
+
+// indexKeys holds the keys for the message index of an account.
+type indexKeys struct {
+	aead    cipher.AEAD
+	nonce   []byte // For HMAC of plaintext, for the synthetic nonce.
+	thread  []byte // For HMAC of thread keys.
+	address []byte // For HMAC of address keys.
+}
+
+// indexKeyCache holds the derived keys per account, for the master key in master.
+var indexKeyCache struct {
+	sync.Mutex
+	master   string
+	accounts map[string]*indexKeys
+}
+
+// encryptionConfigured returns whether encryption at rest is configured.
+func encryptionConfigured() bool {
+	return mox.Conf.Static.MessageEncryption != nil && len(mox.Conf.Static.MessageEncryption.Key) > 0
+}
+
+// accountIndexKeys returns the keys for encrypting the message index of the
+// account, or nil if encryption at rest is not configured. Like the keys for
+// message files, they are derived from the master key and the account name.
+func accountIndexKeys(accountName string) *indexKeys {
+	if !encryptionConfigured() {
+		return nil
+	}
+	master := mox.Conf.Static.MessageEncryption.Key
+
+	indexKeyCache.Lock()
+	defer indexKeyCache.Unlock()
+	if indexKeyCache.accounts == nil || indexKeyCache.master != string(master) {
+		indexKeyCache.master = string(master)
+		indexKeyCache.accounts = map[string]*indexKeys{}
+	}
+	if k, ok := indexKeyCache.accounts[accountName]; ok {
+		return k
+	}
+
+	derive := func(label string) []byte {
+		mac := hmac.New(sha256.New, master)
+		mac.Write([]byte("mox index encryption " + label + " key\x00" + accountName))
+		return mac.Sum(nil)
+	}
+	block, err := aes.NewCipher(derive("data"))
+	if err != nil {
+		panic(fmt.Sprintf("aes cipher with sha256 key: %v", err))
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(fmt.Sprintf("gcm: %v", err))
+	}
+	k := &indexKeys{aead, derive("nonce"), derive("thread"), derive("address")}
+	indexKeyCache.accounts[accountName] = k
+	return k
+}
+
+// ThreadKey returns the value stored in Message.MessageID or Message.SubjectBase
+// for s, a canonical message-id or base subject, for a message in the account.
+// With encryption at rest, it is a keyed hash of s, otherwise s itself. Lookups of
+// messages by these fields must use ThreadKey too.
+func ThreadKey(accountName, s string) string {
+	if s == "" {
+		return ""
+	}
+	k := accountIndexKeys(accountName)
+	if k == nil {
+		return s
+	}
+	return keyedHash(k.thread, s)
+}
+
+// AddressKey returns the value stored in Message.MailFromAddressKey or
+// Message.MsgFromAddressKey for the address, for a message in the account. With
+// encryption at rest, it is a keyed hash of the address, otherwise the address
+// itself. Used for looking up earlier messages from an address for reputation,
+// without reading the sealed localparts of all messages of a domain.
+func AddressKey(accountName string, localpart smtp.Localpart, domain string) string {
+	if domain == "" {
+		return ""
+	}
+	s := localpart.String() + "@" + domain
+	k := accountIndexKeys(accountName)
+	if k == nil {
+		return s
+	}
+	return keyedHash(k.address, s)
+}
+
+func keyedHash(key []byte, s string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(s))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// MarshalBinary returns the form of s as stored in the database, encrypted if
+// encryption at rest is configured. The encrypted form starts with the name of the
+// account, the keys are derived from it, and the account is set again when reading.
+// An empty s is always stored in plain text.
+func (s MessageSealed) MarshalBinary() ([]byte, error) {
+	var buf []byte
+	addBytes := func(b []byte) {
+		// Length 0 is nil, so we can keep the distinction with an empty value.
+		if b == nil {
+			buf = binary.AppendUvarint(buf, 0)
+		} else {
+			buf = binary.AppendUvarint(buf, uint64(len(b))+1)
+			buf = append(buf, b...)
+		}
+	}
+	addBytes(s.MsgPrefix)
+	addBytes(s.ParsedBuf)
+	addBytes([]byte(s.MailFrom))
+	addBytes([]byte(s.MailFromLocalpart))
+	addBytes([]byte(s.RcptToLocalpart))
+	addBytes([]byte(s.RcptToDomain))
+	addBytes([]byte(s.MsgFromLocalpart))
+
+	empty := s.MsgPrefix == nil && s.ParsedBuf == nil && s.MailFrom == "" && s.MailFromLocalpart == "" && s.RcptToLocalpart == "" && s.RcptToDomain == "" && s.MsgFromLocalpart == ""
+	if !encryptionConfigured() || empty {
+		return append([]byte{sealedPlain}, buf...), nil
+	}
+	if s.account == "" {
+		return nil, fmt.Errorf("encrypting sealed message data: account not set")
+	}
+	k := accountIndexKeys(s.account)
+	mac := hmac.New(sha256.New, k.nonce)
+	mac.Write(buf)
+	nonce := mac.Sum(nil)[:k.aead.NonceSize()]
+	out := []byte{sealedEncrypted}
+	out = binary.AppendUvarint(out, uint64(len(s.account)))
+	out = append(out, s.account...)
+	out = append(out, nonce...)
+	return k.aead.Seal(out, nonce, buf, []byte(s.account)), nil
+}
+
+// UnmarshalBinary parses the stored form of s, decrypting if needed. If the data
+// is encrypted and no encryption key is configured, ErrNoIndexKey is returned.
+func (s *MessageSealed) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 {
+		return fmt.Errorf("empty sealed message data")
+	}
+	var buf []byte
+	var account string
+	switch data[0] {
+	case sealedPlain:
+		// Data must be copied, we return slices of it.
+		buf = append([]byte{}, data[1:]...)
+	case sealedEncrypted:
+		if !encryptionConfigured() {
+			return ErrNoIndexKey
+		}
+		n, o := binary.Uvarint(data[1:])
+		if o <= 0 || n > uint64(len(data)-1-o) {
+			return fmt.Errorf("bad account in encrypted sealed message data")
+		}
+		account = string(data[1+o : 1+o+int(n)])
+		buf = data[1+o+int(n):]
+		k := accountIndexKeys(account)
+		if len(buf) < k.aead.NonceSize() {
+			return fmt.Errorf("short encrypted sealed message data")
+		}
+		var err error
+		buf, err = k.aead.Open(nil, buf[:k.aead.NonceSize()], buf[k.aead.NonceSize():], []byte(account))
+		if err != nil {
+			return fmt.Errorf("decrypting sealed message data: %v", err)
+		}
+	default:
+		return fmt.Errorf("unknown format %d for sealed message data", data[0])
+	}
+
+	var err error
+	takeBytes := func() []byte {
+		if err != nil {
+			return nil
+		}
+		n, o := binary.Uvarint(buf)
+		if o <= 0 || n > uint64(len(buf)-o)+1 {
+			err = fmt.Errorf("bad length in sealed message data")
+			return nil
+		} else if n == 0 {
+			buf = buf[o:]
+			return nil
+		}
+		b := buf[o : o+int(n-1)]
+		buf = buf[o+int(n-1):]
+		return b
+	}
+	*s = MessageSealed{
+		MsgPrefix:         takeBytes(),
+		ParsedBuf:         takeBytes(),
+		MailFrom:          string(takeBytes()),
+		MailFromLocalpart: smtp.Localpart(takeBytes()),
+		RcptToLocalpart:   smtp.Localpart(takeBytes()),
+		RcptToDomain:      string(takeBytes()),
+		MsgFromLocalpart:  smtp.Localpart(takeBytes()),
+		account:           account,
+	}
+	if err == nil && len(buf) != 0 {
+		err = fmt.Errorf("%d bytes leftover data in sealed message data", len(buf))
+	}
+	return err
+}
+
+// UpgradeSealed moves fields of messages stored before Message.Sealed existed into
+// Message.Sealed, and when encryption at rest is configured, encrypts them and
+// replaces Message.MessageID and Message.SubjectBase with keyed hashes. The address
+// keys of messages are set too, as keyed hashes when encryption at rest is
+// configured. Messages are processed in batches, with progress kept in the Upgrade
+// record, so an interrupted upgrade continues where it left off.
+//
+// Called when opening an account. Also used for account databases from backups,
+// which must be upgraded before their messages can be used.
+func UpgradeSealed(ctx context.Context, log mlog.Log, accountName string, db *bstore.DB) error {
+	const batchSize = 10000
+
+	encrypted := encryptionConfigured()
+	var total int
+	for {
+		var done bool
+		err := db.Write(ctx, func(tx *bstore.Tx) error {
+			up := Upgrade{ID: 1}
+			err := tx.Get(&up)
+			absent := err == bstore.ErrAbsent
+			if err != nil && !absent {
+				return fmt.Errorf("get upgrade record: %v", err)
+			}
+
+			hash := encrypted && !up.ThreadKeysHashed
+			if up.MessagesLastID == 0 {
+				if up.MessageSealed && !hash {
+					done = true
+					return nil
+				}
+				up.MessagesHashing = hash
+				log.Info("upgrading messages in account database for encryption at rest",
+					slog.Bool("sealing", !up.MessageSealed),
+					slog.Bool("hashing", up.MessagesHashing))
+			} else if up.MessagesHashing && !encrypted {
+				return fmt.Errorf("upgrade of messages for encryption at rest in progress, but no encryption key is configured")
+			}
+
+			q := bstore.QueryTx[Message](tx)
+			q.FilterGreater("ID", up.MessagesLastID)
+			q.SortAsc("ID")
+			q.Limit(batchSize)
+			var ids []int64
+			if err := q.IDs(&ids); err != nil {
+				return fmt.Errorf("listing messages: %v", err)
+			}
+			for _, id := range ids {
+				m := Message{ID: id}
+				if !up.MessageSealed {
+					// Read the legacy fields before writing the message, which would drop them.
+					var fields []string
+					rec, err := tx.Record("Message", strconv.FormatInt(m.ID, 10), &fields)
+					if err != nil {
+						return fmt.Errorf("get message %d as record: %v", m.ID, err)
+					}
+					if err := tx.Get(&m); err != nil {
+						return fmt.Errorf("get message %d: %v", m.ID, err)
+					}
+					legacySealed(rec, &m.Sealed)
+				} else if err := tx.Get(&m); err != nil {
+					return fmt.Errorf("get message %d: %v", m.ID, err)
+				}
+				m.PrepareAccount(accountName)
+				if up.MessagesHashing {
+					m.MessageID = ThreadKey(accountName, m.MessageID)
+					m.SubjectBase = ThreadKey(accountName, m.SubjectBase)
+
+					// bstore doesn't write unchanged records, and compares fields in their stored
+					// form. Sealed stored in plain text compares equal to its encrypted form now that
+					// we have a key, so we first write the message without it.
+					nm := m
+					nm.Sealed = MessageSealed{}
+					if err := tx.Update(&nm); err != nil {
+						return fmt.Errorf("update message %d: %v", m.ID, err)
+					}
+				}
+				if err := tx.Update(&m); err != nil {
+					return fmt.Errorf("update message %d: %v", m.ID, err)
+				}
+				up.MessagesLastID = m.ID
+			}
+			total += len(ids)
+
+			if len(ids) < batchSize {
+				up.MessageSealed = true
+				up.ThreadKeysHashed = up.ThreadKeysHashed || up.MessagesHashing
+				up.MessagesLastID = 0
+				up.MessagesHashing = false
+				log.Info("upgrading messages in account database completed", slog.Int("messages", total))
+			}
+			if absent {
+				return tx.Insert(&up)
+			}
+			return tx.Update(&up)
+		})
+		if err != nil {
+			return err
+		} else if done {
+			return nil
+		}
+	}
+}
+
+// legacySealed sets fields in s from the fields of an old message record, as
+// parsed by bstore without Go type. Records written after the fields were moved
+// don't have these fields, s is left unchanged for them.
+func legacySealed(rec map[string]any, s *MessageSealed) {
+	if _, ok := rec["ParsedBuf"]; !ok {
+		return
+	}
+	buf := func(k string) []byte {
+		if v, ok := rec[k].([]byte); ok && len(v) > 0 {
+			return v
+		}
+		return nil
+	}
+	str := func(k string) string {
+		v, _ := rec[k].(string)
+		return v
+	}
+	s.MsgPrefix = buf("MsgPrefix")
+	s.ParsedBuf = buf("ParsedBuf")
+	s.MailFrom = str("MailFrom")
+	s.MailFromLocalpart = smtp.Localpart(str("MailFromLocalpart"))
+	s.RcptToLocalpart = smtp.Localpart(str("RcptToLocalpart"))
+	s.RcptToDomain = str("RcptToDomain")
+	s.MsgFromLocalpart = smtp.Localpart(str("MsgFromLocalpart"))
+}
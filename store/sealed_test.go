@@ -0,0 +1,197 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/smtp"
+)
+
+func TestSealed(t *testing.T) {
+	log := pkglog
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	key := &config.MessageEncryption{Key: bytes.Repeat([]byte{1}, 32)}
+	defer func() {
+		mox.Conf.Static.MessageEncryption = nil
+	}()
+	defer Switchboard()()
+
+	deliver := func(acc *Account, msg string) Message {
+		t.Helper()
+		msgFile, err := CreateMessageTemp(log, "sealed-test")
+		tcheck(t, err, "create temp message")
+		defer os.Remove(msgFile.Name())
+		defer msgFile.Close()
+		_, err = msgFile.Write([]byte(msg))
+		tcheck(t, err, "write message")
+		m := Message{Received: time.Now(), Size: int64(len(msg)), MailFromDomain: "example.org", Sealed: MessageSealed{MailFrom: "remote@example.org", MailFromLocalpart: "remote", RcptToLocalpart: "mjl", RcptToDomain: "mox.example"}}
+		acc.WithWLock(func() {
+			err = acc.DeliverMailbox(log, "Inbox", &m, msgFile)
+		})
+		tcheck(t, err, "deliver message")
+		return m
+	}
+	closeAccount := func(acc *Account) {
+		t.Helper()
+		err := acc.Close()
+		tcheck(t, err, "closing account")
+		acc.CheckClosed()
+	}
+
+	const msg1 = "Message-ID: <first@example.org>\r\nSubject: Confidential plans\r\n\r\nbody\r\n"
+	const msg2 = "Message-ID: <second@example.org>\r\nIn-Reply-To: <first@example.org>\r\nSubject: Re: Confidential plans\r\n\r\nbody\r\n"
+
+	// Without key, messages are stored in plain text.
+	acc, err := OpenAccount(log, "mjl")
+	tcheck(t, err, "open account")
+	m1 := deliver(acc, msg1)
+	tcompare(t, m1.MessageID, "first@example.org")
+	tcompare(t, m1.SubjectBase, "confidential plans")
+	tcompare(t, m1.MailFromAddressKey, "remote@example.org")
+	closeAccount(acc)
+
+	// With key, existing messages are encrypted and thread keys hashed when opening.
+	mox.Conf.Static.MessageEncryption = key
+	acc, err = OpenAccount(log, "mjl")
+	tcheck(t, err, "open account")
+	up := Upgrade{ID: 1}
+	err = acc.DB.Get(ctxbg, &up)
+	tcheck(t, err, "get upgrade")
+	tcompare(t, up.MessageSealed, true)
+	tcompare(t, up.ThreadKeysHashed, true)
+	tcompare(t, up.MessagesLastID, int64(0))
+
+	xm1 := Message{ID: m1.ID}
+	err = acc.DB.Get(ctxbg, &xm1)
+	tcheck(t, err, "get message")
+	tcompare(t, xm1.MessageID, ThreadKey("mjl", "first@example.org"))
+	tcompare(t, xm1.MessageID != "first@example.org", true)
+	tcompare(t, xm1.SubjectBase, ThreadKey("mjl", "confidential plans"))
+	tcompare(t, xm1.MailFromAddressKey, AddressKey("mjl", "remote", "example.org"))
+	tcompare(t, xm1.MailFromAddressKey != "remote@example.org", true)
+	tcompare(t, xm1.Sealed, m1.Sealed)
+
+	// Keys are derived per account.
+	tcompare(t, ThreadKey("mjl", "first@example.org") != ThreadKey("other", "first@example.org"), true)
+	tcompare(t, AddressKey("mjl", "remote", "example.org") != AddressKey("other", "remote", "example.org"), true)
+
+	// Threading works with hashed keys, for new messages and lookups.
+	m2 := deliver(acc, msg2)
+	tcompare(t, m2.ThreadID, m1.ThreadID)
+	tcompare(t, m2.ThreadParentIDs, []int64{m1.ID})
+	q := bstore.QueryDB[Message](ctxbg, acc.DB)
+	q.FilterNonzero(Message{MessageID: ThreadKey("mjl", "first@example.org")})
+	xm1, err = q.Get()
+	tcheck(t, err, "get message by message-id")
+	tcompare(t, xm1.ID, m1.ID)
+
+	// Messages from an address can be found through the index on the address key.
+	n, err := bstore.QueryDB[Message](ctxbg, acc.DB).FilterNonzero(Message{MailFromAddressKey: AddressKey("mjl", "remote", "example.org")}).Count()
+	tcheck(t, err, "count messages by address key")
+	tcompare(t, n, 2)
+
+	// Sealed data cannot be encrypted without knowing the account.
+	_, err = MessageSealed{MailFrom: "remote@example.org"}.MarshalBinary()
+	if err == nil {
+		t.Fatalf("marshal of sealed data without account succeeded, expected error")
+	}
+	_, err = MessageSealed{}.MarshalBinary()
+	tcheck(t, err, "marshal empty sealed data without account")
+
+	// Without the key, the encrypted fields cannot be read.
+	mox.Conf.Static.MessageEncryption = nil
+	err = acc.DB.Get(ctxbg, &Message{ID: m2.ID})
+	if !errors.Is(err, ErrNoIndexKey) {
+		t.Fatalf("got err %v, expected ErrNoIndexKey", err)
+	}
+	mox.Conf.Static.MessageEncryption = key
+	closeAccount(acc)
+
+	// A new account database with a key never has the data in plain text.
+	os.RemoveAll("../testdata/store/data")
+	acc, err = OpenAccount(log, "mjl")
+	tcheck(t, err, "open account")
+	deliver(acc, msg1)
+	dbpath := acc.DBPath
+	closeAccount(acc)
+	buf, err := os.ReadFile(dbpath)
+	tcheck(t, err, "read database")
+	for _, s := range []string{"Confidential", "first@example.org", "remote@example.org"} {
+		tcompare(t, bytes.Contains(buf, []byte(s)), false)
+	}
+}
+
+// legacyMessage is a message as stored before the fields were moved into
+// Message.Sealed.
+type legacyMessage struct {
+	ID                int64 `bstore:"typename Message"`
+	UID               UID   `bstore:"nonzero"`
+	MailboxID         int64 `bstore:"nonzero,ref Mailbox"`
+	MailFrom          string
+	MailFromLocalpart smtp.Localpart
+	MailFromDomain    string
+	MessageID         string `bstore:"index"`
+	SubjectBase       string `bstore:"index"`
+	MsgPrefix         []byte
+	ParsedBuf         []byte
+}
+
+func TestUpgradeSealed(t *testing.T) {
+	log := pkglog
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	mox.Conf.Static.MessageEncryption = &config.MessageEncryption{Key: bytes.Repeat([]byte{1}, 32)}
+	defer func() {
+		mox.Conf.Static.MessageEncryption = nil
+	}()
+
+	dbpath := filepath.Join(t.TempDir(), "index.db")
+	opts := bstore.Options{Timeout: 5 * time.Second, Perm: 0660}
+	db, err := bstore.Open(ctxbg, dbpath, &opts, Mailbox{}, legacyMessage{})
+	tcheck(t, err, "open legacy database")
+	mb := Mailbox{Name: "Inbox", UIDNext: 2}
+	err = db.Insert(ctxbg, &mb)
+	tcheck(t, err, "insert mailbox")
+	lm := legacyMessage{UID: 1, MailboxID: mb.ID, MailFrom: "remote@example.org", MailFromLocalpart: "remote", MailFromDomain: "example.org", MessageID: "first@example.org", SubjectBase: "plans", MsgPrefix: []byte("Received: x\r\n"), ParsedBuf: []byte("{}")}
+	err = db.Insert(ctxbg, &lm)
+	tcheck(t, err, "insert legacy message")
+	err = db.Close()
+	tcheck(t, err, "close database")
+
+	db, err = bstore.Open(ctxbg, dbpath, &opts, DBTypes...)
+	tcheck(t, err, "open database")
+	defer db.Close()
+	err = UpgradeSealed(ctxbg, log, "mjl", db)
+	tcheck(t, err, "upgrade")
+
+	m := Message{ID: lm.ID}
+	err = db.Get(ctxbg, &m)
+	tcheck(t, err, "get message")
+	tcompare(t, m.Sealed, MessageSealed{MsgPrefix: lm.MsgPrefix, ParsedBuf: lm.ParsedBuf, MailFrom: lm.MailFrom, MailFromLocalpart: lm.MailFromLocalpart, account: "mjl"})
+	tcompare(t, m.MessageID, ThreadKey("mjl", "first@example.org"))
+	tcompare(t, m.SubjectBase, ThreadKey("mjl", "plans"))
+	tcompare(t, m.MailFromAddressKey, AddressKey("mjl", "remote", "example.org"))
+	up := Upgrade{ID: 1}
+	err = db.Get(ctxbg, &up)
+	tcheck(t, err, "get upgrade")
+	tcompare(t, up.MessageSealed, true)
+	tcompare(t, up.ThreadKeysHashed, true)
+
+	// Upgrading again doesn't change anything.
+	err = UpgradeSealed(ctxbg, log, "mjl", db)
+	tcheck(t, err, "upgrade")
+	xm := Message{ID: lm.ID}
+	err = db.Get(ctxbg, &xm)
+	tcheck(t, err, "get message")
+	tcompare(t, xm.MessageID, m.MessageID)
+}
@@ -0,0 +1,90 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/smtp"
+)
+
+// ErrSenderListParam is returned when adding a sender list entry with invalid
+// parameters.
+var ErrSenderListParam = errors.New("invalid sender list parameter")
+
+// SenderAction is the action for incoming messages from a sender in the
+// allow/deny sender lists.
+type SenderAction string
+
+const (
+	SenderAllow  SenderAction = "allow"  // Skip junk filtering and DNS block list checks. Only for messages with a DMARC pass for the message From address, or SPF pass for the MAIL FROM address.
+	SenderReject SenderAction = "reject" // Reject the message.
+	SenderJunk   SenderAction = "junk"   // Deliver to the Junk mailbox, marked as junk.
+)
+
+// SenderListEntry is an entry in the allow/deny sender lists of an account,
+// evaluated during incoming delivery. Entries of the account take precedence
+// over the sender lists of the recipient domain.
+type SenderListEntry struct {
+	ID      int64
+	Sender  string       `bstore:"nonzero,unique"` // Email address, or domain as "@domain". In canonical form, see mox.ParseSender.
+	Action  SenderAction `bstore:"nonzero"`
+	Created time.Time    `bstore:"default now"`
+}
+
+// SenderList returns the sender list entries of the account, ordered by sender.
+func (a *Account) SenderList(ctx context.Context) ([]SenderListEntry, error) {
+	return bstore.QueryDB[SenderListEntry](ctx, a.DB).SortAsc("Sender").List()
+}
+
+// SenderListSet adds sender to the sender list with action, or changes the
+// action of an existing entry. Sender is parsed and stored in canonical form.
+func (a *Account) SenderListSet(ctx context.Context, sender string, action SenderAction) (SenderListEntry, error) {
+	switch action {
+	case SenderAllow, SenderReject, SenderJunk:
+	default:
+		return SenderListEntry{}, fmt.Errorf("%w: unknown action %q", ErrSenderListParam, action)
+	}
+	s, err := mox.ParseSender(sender)
+	if err != nil {
+		return SenderListEntry{}, fmt.Errorf("%w: parsing sender: %v", ErrSenderListParam, err)
+	}
+	e := SenderListEntry{Sender: s, Action: action}
+	err = a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		x, err := bstore.QueryTx[SenderListEntry](tx).FilterNonzero(SenderListEntry{Sender: s}).Get()
+		if err == bstore.ErrAbsent {
+			return tx.Insert(&e)
+		} else if err != nil {
+			return err
+		}
+		x.Action = action
+		e = x
+		return tx.Update(&e)
+	})
+	return e, err
+}
+
+// SenderListRemove removes a sender list entry.
+func (a *Account) SenderListRemove(ctx context.Context, id int64) error {
+	return a.DB.Delete(ctx, &SenderListEntry{ID: id})
+}
+
+// SenderListMatch returns the action of the most specific entry in the sender
+// list of the account that matches addr, and the matching sender. An empty
+// action is returned if no entry matches.
+func (a *Account) SenderListMatch(tx *bstore.Tx, addr smtp.Address) (SenderAction, string, error) {
+	for _, k := range mox.SenderKeys(addr) {
+		e, err := bstore.QueryTx[SenderListEntry](tx).FilterNonzero(SenderListEntry{Sender: k}).Get()
+		if err == bstore.ErrAbsent {
+			continue
+		} else if err != nil {
+			return "", "", err
+		}
+		return e.Action, e.Sender, nil
+	}
+	return "", "", nil
+}
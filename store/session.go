@@ -80,6 +80,10 @@ func ensureAccountSessions(ctx context.Context, log mlog.Log, accountName string
 // CSRF check is done. Otherwise it must be the csrf token associated with the
 // session token.
 func SessionUse(ctx context.Context, log mlog.Log, accountName string, sessionToken SessionToken, csrfToken CSRFToken) (LoginSession, error) {
+	if err := CheckLoginDisabled(accountName); err != nil {
+		return LoginSession{}, err
+	}
+
 	sessions.Lock()
 	defer sessions.Unlock()
 
@@ -3,21 +3,9 @@ package store
 import (
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
-var (
-	register   = make(chan *Comm)
-	unregister = make(chan *Comm)
-	broadcast  = make(chan changeReq)
-)
-
-type changeReq struct {
-	acc     *Account
-	comm    *Comm // Can be nil.
-	changes []Change
-	done    chan struct{}
-}
-
 type UID uint32 // IMAP UID.
 
 // Change to mailboxes/subscriptions/messages in an account. One of the Change*
@@ -107,99 +95,109 @@ type ChangeMailboxKeywords struct {
 
 var switchboardBusy atomic.Bool
 
-// Switchboard distributes changes to accounts to interested listeners. See Comm and Change.
-func Switchboard() (stop func()) {
-	regs := map[*Account]map[*Comm]struct{}{}
-	done := make(chan struct{})
+// Limits for changes pending for a Comm. When a Comm has more than
+// commMaxPending changes pending after a broadcast, the broadcaster waits at most
+// commBackpressureTimeout for the Comm to retrieve its changes, after releasing
+// the account lock, see Account.WithWLock and Account.WithRLock. A Comm that
+// doesn't retrieve its changes in time, e.g. an IMAP connection that isn't idling
+// and doesn't send commands, is marked as stalled and isn't waited for again
+// until it retrieves its changes.
+var (
+	commMaxPending          = 10000
+	commBackpressureTimeout = time.Second
+)
 
+// changeHook is called for all broadcasted changes, see SetChangeHook.
+var changeHook atomic.Pointer[func(accName string, changes []Change)]
+
+// SetChangeHook sets a function that is called with the changes broadcasted for
+// all accounts, e.g. for sending push notifications about new messages. The
+// function is called by the broadcaster, possibly concurrently for different
+// accounts, and must not block. A nil fn removes the hook.
+func SetChangeHook(fn func(accName string, changes []Change)) {
+	if fn == nil {
+		changeHook.Store(nil)
+	} else {
+		changeHook.Store(&fn)
+	}
+}
+
+// Switchboard enables distribution of changes to accounts to interested
+// listeners. See Comm and Change. Changes broadcasted while the switchboard isn't
+// running are dropped.
+//
+// Changes are distributed by the broadcaster directly to the Comms registered for
+// the account, under a lock of the account, so accounts don't contend with each
+// other and no goroutine is needed per account or Comm.
+func Switchboard() (stop func()) {
 	if !switchboardBusy.CompareAndSwap(false, true) {
 		panic("switchboard already busy")
 	}
-
-	go func() {
-		for {
-			select {
-			case c := <-register:
-				if _, ok := regs[c.acc]; !ok {
-					regs[c.acc] = map[*Comm]struct{}{}
-				}
-				regs[c.acc][c] = struct{}{}
-
-			case c := <-unregister:
-				delete(regs[c.acc], c)
-				if len(regs[c.acc]) == 0 {
-					delete(regs, c.acc)
-				}
-
-			case chReq := <-broadcast:
-				acc := chReq.acc
-				for c := range regs[acc] {
-					// Do not send the broadcaster back their own changes. chReq.comm is nil if not
-					// originating from a comm, so won't match in that case.
-					if c == chReq.comm {
-						continue
-					}
-
-					c.Lock()
-					c.changes = append(c.changes, chReq.changes...)
-					c.Unlock()
-
-					select {
-					case c.Pending <- struct{}{}:
-					default:
-					}
-				}
-				chReq.done <- struct{}{}
-
-			case <-done:
-				done <- struct{}{}
-				return
-			}
-		}
-	}()
 	return func() {
-		done <- struct{}{}
-		<-done
 		if !switchboardBusy.CompareAndSwap(true, false) {
 			panic("switchboard already unregistered?")
 		}
 	}
 }
 
-// Comm handles communication with the goroutine that maintains the
-// account/mailbox/message state.
+// comms are the Comms registered for an account.
+type comms struct {
+	sync.Mutex
+	l       map[*Comm]struct{}
+	waiters []commWaiter // Comms with too many pending changes, see waitComms.
+}
+
+type commWaiter struct {
+	c       *Comm
+	drained chan struct{}
+}
+
+// Comm receives changes broadcasted for an account, e.g. for an IMAP connection or
+// webmail session.
 type Comm struct {
 	Pending chan struct{} // Receives block until changes come in, e.g. for IMAP IDLE.
 
 	acc *Account
 
 	sync.Mutex
-	changes []Change
+	changes  []Change         // Nil elements are changes superseded by later changes, see add.
+	npending int              // Non-nil elements in changes.
+	flags    map[flagsKey]int // Index in changes of pending ChangeFlags.
+	counts   map[int64]int    // Index in changes of pending ChangeMailboxCounts, by mailbox ID.
+	drained  chan struct{}    // If not nil, closed by Get, for backpressure.
+	stalled  bool             // Did not retrieve changes in time during backpressure.
+}
+
+type flagsKey struct {
+	mailboxID int64
+	uid       UID
 }
 
 // Register starts a Comm for the account. Unregister must be called.
 func RegisterComm(acc *Account) *Comm {
 	c := &Comm{
-		Pending: make(chan struct{}, 1), // Bufferend so Switchboard can just do a non-blocking send.
+		Pending: make(chan struct{}, 1), // Bufferend so broadcaster can just do a non-blocking send.
 		acc:     acc,
 	}
-	register <- c
+	acc.comms.Lock()
+	defer acc.comms.Unlock()
+	if acc.comms.l == nil {
+		acc.comms.l = map[*Comm]struct{}{}
+	}
+	acc.comms.l[c] = struct{}{}
 	return c
 }
 
 // Unregister stops this Comm.
 func (c *Comm) Unregister() {
-	unregister <- c
+	c.acc.comms.Lock()
+	defer c.acc.comms.Unlock()
+	delete(c.acc.comms.l, c)
 }
 
 // Broadcast ensures changes are sent to other Comms.
 func (c *Comm) Broadcast(ch []Change) {
-	if len(ch) == 0 {
-		return
-	}
-	done := make(chan struct{}, 1)
-	broadcast <- changeReq{c.acc, c, ch, done}
-	<-done
+	broadcast(c.acc, c, ch)
 }
 
 // Get retrieves all pending changes. If no changes are pending a nil or empty list
@@ -208,16 +206,145 @@ func (c *Comm) Get() []Change {
 	c.Lock()
 	defer c.Unlock()
 	l := c.changes
+	if c.npending < len(l) {
+		// Remove superseded changes.
+		n := l[:0]
+		for _, ch := range l {
+			if ch != nil {
+				n = append(n, ch)
+			}
+		}
+		l = n
+	}
 	c.changes = nil
+	c.npending = 0
+	c.flags = nil
+	c.counts = nil
+	if c.drained != nil {
+		close(c.drained)
+		c.drained = nil
+	}
+	c.stalled = false
 	return l
 }
 
+// add adds changes to the pending changes, coalescing them with pending changes
+// they supersede: Flag changes for a message and count changes for a mailbox are
+// replaced by the latest change. Flag changes for a message include the flags
+// changed by the superseded change.
+//
+// If too many changes are pending, a channel is returned that is closed when the
+// changes are retrieved.
+func (c *Comm) add(l []Change) chan struct{} {
+	c.Lock()
+	defer c.Unlock()
+
+	for _, ch := range l {
+		switch x := ch.(type) {
+		case ChangeFlags:
+			k := flagsKey{x.MailboxID, x.UID}
+			if i, ok := c.flags[k]; ok {
+				x.Mask = x.Mask.Set(c.changes[i].(ChangeFlags).Mask, FlagsAll)
+				ch = x
+				c.changes[i] = nil
+				c.npending--
+			} else if c.flags == nil {
+				c.flags = map[flagsKey]int{}
+			}
+			c.flags[k] = len(c.changes)
+		case ChangeMailboxCounts:
+			if i, ok := c.counts[x.MailboxID]; ok {
+				c.changes[i] = nil
+				c.npending--
+			} else if c.counts == nil {
+				c.counts = map[int64]int{}
+			}
+			c.counts[x.MailboxID] = len(c.changes)
+		}
+		c.changes = append(c.changes, ch)
+		c.npending++
+	}
+
+	select {
+	case c.Pending <- struct{}{}:
+	default:
+	}
+
+	if c.npending <= commMaxPending || c.stalled {
+		return nil
+	}
+	if c.drained == nil {
+		c.drained = make(chan struct{})
+	}
+	return c.drained
+}
+
 // BroadcastChanges ensures changes are sent to all listeners on the accoount.
 func BroadcastChanges(acc *Account, ch []Change) {
+	broadcast(acc, nil, ch)
+}
+
+// broadcast updates the mailbox UIDs kept in memory for the account, and adds
+// changes to the Comms of the account, except to the originating Comm from (which
+// can be nil). Comms that have too many changes pending are
+// registered with the account, to be waited for by waitComms after the account
+// lock is released.
+func broadcast(acc *Account, from *Comm, ch []Change) {
 	if len(ch) == 0 {
 		return
 	}
-	done := make(chan struct{}, 1)
-	broadcast <- changeReq{acc, nil, ch, done}
-	<-done
+
+	acc.mailboxUIDs.apply(ch)
+
+	if !switchboardBusy.Load() {
+		return
+	}
+
+	if fn := changeHook.Load(); fn != nil {
+		(*fn)(acc.Name, ch)
+	}
+
+	acc.comms.Lock()
+	defer acc.comms.Unlock()
+	for c := range acc.comms.l {
+		// Do not send the broadcaster back their own changes.
+		if c == from {
+			continue
+		}
+		if drained := c.add(ch); drained != nil {
+			acc.comms.waiters = append(acc.comms.waiters, commWaiter{c, drained})
+		}
+	}
+}
+
+// waitComms waits for Comms with too many pending changes after a broadcast, at
+// most commBackpressureTimeout. Comms that don't retrieve their changes in time
+// are marked as stalled. Must be called without the account lock held, so the
+// Comms can make progress and other operations on the account aren't blocked.
+func (a *Account) waitComms() {
+	a.comms.Lock()
+	waiters := a.comms.waiters
+	a.comms.waiters = nil
+	a.comms.Unlock()
+
+	if len(waiters) == 0 {
+		return
+	}
+	timer := time.NewTimer(commBackpressureTimeout)
+	defer timer.Stop()
+	for i, w := range waiters {
+		select {
+		case <-w.drained:
+			continue
+		case <-timer.C:
+		}
+		for _, w := range waiters[i:] {
+			w.c.Lock()
+			if w.c.drained == w.drained {
+				w.c.stalled = true
+			}
+			w.c.Unlock()
+		}
+		break
+	}
 }
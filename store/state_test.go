@@ -0,0 +1,86 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestComm(t *testing.T) {
+	defer Switchboard()()
+
+	acc := &Account{Name: "mjl"}
+	c0 := RegisterComm(acc)
+	defer c0.Unregister()
+	c1 := RegisterComm(acc)
+	defer c1.Unregister()
+
+	// Changes are not sent back to the broadcaster.
+	c0.Broadcast([]Change{ChangeAddUID{MailboxID: 1, UID: 1}})
+	tcompare(t, len(c0.Get()), 0)
+	select {
+	case <-c1.Pending:
+	default:
+		t.Fatalf("no pending changes")
+	}
+
+	// Flag and count changes are coalesced with pending changes.
+	BroadcastChanges(acc, []Change{
+		ChangeFlags{MailboxID: 1, UID: 1, ModSeq: 2, Mask: Flags{Seen: true}, Flags: Flags{Seen: true}},
+		ChangeMailboxCounts{MailboxID: 1, MailboxCounts: MailboxCounts{Total: 1}},
+		ChangeFlags{MailboxID: 1, UID: 2, ModSeq: 2, Mask: Flags{Seen: true}, Flags: Flags{Seen: true}},
+	})
+	BroadcastChanges(acc, []Change{
+		ChangeFlags{MailboxID: 1, UID: 1, ModSeq: 3, Mask: Flags{Flagged: true}, Flags: Flags{Seen: true, Flagged: true}},
+		ChangeMailboxCounts{MailboxID: 1, MailboxCounts: MailboxCounts{Total: 2}},
+	})
+	tcompare(t, c1.Get(), []Change{
+		ChangeAddUID{MailboxID: 1, UID: 1},
+		ChangeFlags{MailboxID: 1, UID: 2, ModSeq: 2, Mask: Flags{Seen: true}, Flags: Flags{Seen: true}},
+		ChangeFlags{MailboxID: 1, UID: 1, ModSeq: 3, Mask: Flags{Seen: true, Flagged: true}, Flags: Flags{Seen: true, Flagged: true}},
+		ChangeMailboxCounts{MailboxID: 1, MailboxCounts: MailboxCounts{Total: 2}},
+	})
+	tcompare(t, len(c1.Get()), 0)
+
+	// Broadcaster waits for a Comm with too many pending changes, but not again after
+	// the Comm stalled. The waiting is done after the account lock is released, so
+	// the Comm can get the lock while retrieving its changes.
+	defer func(n int, d time.Duration) {
+		commMaxPending = n
+		commBackpressureTimeout = d
+	}(commMaxPending, commBackpressureTimeout)
+	commMaxPending = 1
+	commBackpressureTimeout = 50 * time.Millisecond
+
+	c0.Get()
+	select {
+	case <-c0.Pending:
+	default:
+	}
+	gotc := make(chan int)
+	go func() {
+		<-c0.Pending
+		acc.WithRLock(func() {
+			gotc <- len(c0.Get())
+		})
+	}()
+	acc.WithWLock(func() {
+		BroadcastChanges(acc, []Change{ChangeAddUID{MailboxID: 1, UID: 3}, ChangeAddUID{MailboxID: 1, UID: 4}})
+	})
+	tcompare(t, <-gotc, 2)
+	tcompare(t, c0.stalled, false)
+	tcompare(t, len(c1.Get()), 2)
+
+	acc.WithWLock(func() {
+		BroadcastChanges(acc, []Change{ChangeAddUID{MailboxID: 1, UID: 5}, ChangeAddUID{MailboxID: 1, UID: 6}})
+	})
+	tcompare(t, c0.stalled && c1.stalled, true)
+	t0 := time.Now()
+	acc.WithWLock(func() {
+		BroadcastChanges(acc, []Change{ChangeAddUID{MailboxID: 1, UID: 7}})
+	})
+	if d := time.Since(t0); d >= commBackpressureTimeout {
+		t.Fatalf("broadcast waited %v for stalled comms", d)
+	}
+	tcompare(t, len(c0.Get()), 3)
+	tcompare(t, c0.stalled, false)
+}
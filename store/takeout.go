@@ -0,0 +1,141 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+)
+
+// TakeoutSettings is stored as settings.json in a takeout archive.
+type TakeoutSettings struct {
+	Settings            Settings
+	FromAddressSettings []FromAddressSettings
+	Identities          []Identity
+	RetentionRules      []RetentionRule
+	SenderList          []SenderListEntry
+}
+
+// Takeout writes all data of the account to archiver, for handing to the user,
+// e.g. before the account is removed. The archive has these files:
+//
+//   - account.json, the account configuration, including addresses and delivery
+//     rulesets, with webhook authorization headers removed.
+//   - settings.json, a TakeoutSettings with webmail settings, identities,
+//     retention rules and sender lists.
+//   - filters.json, the filter rules.
+//   - contacts.vcf, the contacts as vCards.
+//   - calendar.ics, the calendar events.
+//   - messages/, all mailboxes with their messages in maildir format, as written
+//     by ExportMessages.
+func Takeout(ctx context.Context, log mlog.Log, acc *Account, archiver Archiver) error {
+	conf, ok := mox.Conf.Account(acc.Name)
+	if !ok {
+		return ErrAccountUnknown
+	}
+	if conf.OutgoingWebhook != nil {
+		wh := *conf.OutgoingWebhook
+		wh.Authorization = ""
+		conf.OutgoingWebhook = &wh
+	}
+	if conf.IncomingWebhook != nil {
+		wh := *conf.IncomingWebhook
+		wh.Authorization = ""
+		conf.IncomingWebhook = &wh
+	}
+
+	var settings TakeoutSettings
+	var filters []FilterRule
+	var contacts, calendar bytes.Buffer
+	err := acc.DB.Read(ctx, func(tx *bstore.Tx) error {
+		settings.Settings = Settings{ID: 1}
+		if err := tx.Get(&settings.Settings); err != nil && err != bstore.ErrAbsent {
+			return fmt.Errorf("get settings: %v", err)
+		}
+		var err error
+		if settings.FromAddressSettings, err = bstore.QueryTx[FromAddressSettings](tx).List(); err != nil {
+			return fmt.Errorf("list from address settings: %v", err)
+		}
+		if settings.Identities, err = bstore.QueryTx[Identity](tx).SortAsc("Address").List(); err != nil {
+			return fmt.Errorf("list identities: %v", err)
+		}
+		if settings.RetentionRules, err = bstore.QueryTx[RetentionRule](tx).List(); err != nil {
+			return fmt.Errorf("list retention rules: %v", err)
+		}
+		if settings.SenderList, err = bstore.QueryTx[SenderListEntry](tx).SortAsc("Sender").List(); err != nil {
+			return fmt.Errorf("list sender list: %v", err)
+		}
+		if filters, err = bstore.QueryTx[FilterRule](tx).SortAsc("Position", "ID").List(); err != nil {
+			return fmt.Errorf("list filter rules: %v", err)
+		}
+		err = bstore.QueryTx[Contact](tx).ForEach(func(c Contact) error {
+			contacts.Write(c.CardData())
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("list contacts: %v", err)
+		}
+		err = bstore.QueryTx[CalendarEvent](tx).SortAsc("Start").ForEach(func(e CalendarEvent) error {
+			calendar.Write(e.CalendarData())
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("list calendar events: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	add := func(name string, buf []byte) error {
+		w, err := archiver.Create(name, int64(len(buf)), now)
+		if err != nil {
+			return fmt.Errorf("adding %s: %w", name, err)
+		}
+		if _, err := w.Write(buf); err != nil {
+			xerr := w.Close()
+			log.Check(xerr, "closing file in archive after error")
+			return fmt.Errorf("writing %s: %w", name, err)
+		}
+		if err := w.Close(); err != nil {
+			return fmt.Errorf("closing %s: %w", name, err)
+		}
+		return nil
+	}
+	addJSON := func(name string, v any) error {
+		buf, err := json.MarshalIndent(v, "", "\t")
+		if err != nil {
+			return fmt.Errorf("marshal %s: %v", name, err)
+		}
+		return add(name, append(buf, '\n'))
+	}
+
+	if err := addJSON("account.json", struct {
+		Name string
+		config.Account
+	}{acc.Name, conf}); err != nil {
+		return err
+	}
+	if err := addJSON("settings.json", settings); err != nil {
+		return err
+	}
+	if err := addJSON("filters.json", filters); err != nil {
+		return err
+	}
+	if err := add("contacts.vcf", contacts.Bytes()); err != nil {
+		return err
+	}
+	if err := add("calendar.ics", calendar.Bytes()); err != nil {
+		return err
+	}
+	return ExportMessages(ctx, log, acc.DB, acc.Dir, prefixArchiver{archiver, "messages/"}, ExportMaildir, "", true, ExportFilter{})
+}
@@ -26,7 +26,7 @@ import (
 // may have a threadid 0. That results in this message getting threadid 0, which
 // will handled by the background upgrade process assigning a threadid when it gets
 // to this message.
-func assignThread(log mlog.Log, tx *bstore.Tx, m *Message, part *message.Part) error {
+func assignThread(log mlog.Log, tx *bstore.Tx, accountName string, m *Message, part *message.Part) error {
 	if m.MessageID != "" {
 		// Match against existing different message with same Message-ID.
 		q := bstore.QueryTx[Message](tx)
@@ -54,7 +54,7 @@ func assignThread(log mlog.Log, tx *bstore.Tx, m *Message, part *message.Part) e
 		log.Errorx("assigning threads: parsing references/in-reply-to headers, not matching by message-id", err, slog.Int64("msgid", m.ID))
 	}
 	for i := len(messageIDs) - 1; i >= 0; i-- {
-		messageID := messageIDs[i]
+		messageID := ThreadKey(accountName, messageIDs[i])
 		if messageID == m.MessageID {
 			continue
 		}
@@ -73,7 +73,9 @@ func assignThread(log mlog.Log, tx *bstore.Tx, m *Message, part *message.Part) e
 
 	var isResp bool
 	if part != nil && part.Envelope != nil {
-		m.SubjectBase, isResp = message.ThreadSubject(part.Envelope.Subject, false)
+		var subjectBase string
+		subjectBase, isResp = message.ThreadSubject(part.Envelope.Subject, false)
+		m.SubjectBase = ThreadKey(accountName, subjectBase)
 	}
 	if !isResp || m.SubjectBase == "" {
 		return nil
@@ -146,7 +148,7 @@ func (a *Account) ResetThreading(ctx context.Context, log mlog.Log, batchSize in
 				var part struct {
 					Envelope *message.Envelope
 				}
-				if err := json.Unmarshal(m.ParsedBuf, &part); err != nil {
+				if err := json.Unmarshal(m.Sealed.ParsedBuf, &part); err != nil {
 					log.Errorx("unmarshal json parsedbuf for setting message-id, skipping", err, slog.Int64("msgid", m.ID))
 				} else {
 					m.MessageID = ""
@@ -155,10 +157,11 @@ func (a *Account) ResetThreading(ctx context.Context, log mlog.Log, batchSize in
 						if err != nil {
 							log.Debugx("parsing message-id, skipping", err, slog.Int64("msgid", m.ID), slog.String("messageid", part.Envelope.MessageID))
 						}
-						m.MessageID = s
+						m.MessageID = ThreadKey(a.Name, s)
 					}
 					if part.Envelope != nil {
-						m.SubjectBase, _ = message.ThreadSubject(part.Envelope.Subject, false)
+						subjectBase, _ := message.ThreadSubject(part.Envelope.Subject, false)
+						m.SubjectBase = ThreadKey(a.Name, subjectBase)
 					}
 				}
 				w.Out = m
@@ -296,6 +299,9 @@ func (a *Account) AssignThreads(ctx context.Context, log mlog.Log, txOpt *bstore
 		if err != nil {
 			log.Errorx("assigning threads: parsing references/in-reply-to headers, not matching by message-id", err, slog.Int64("msgid", m.ID))
 		}
+		for i, s := range refids {
+			refids[i] = ThreadKey(a.Name, s)
+		}
 
 		for i := len(refids) - 1; i >= 0; i-- {
 			messageID := refids[i]
@@ -318,6 +324,7 @@ func (a *Account) AssignThreads(ctx context.Context, log mlog.Log, txOpt *bstore
 		var isResp bool
 		if subject != "" {
 			subjectBase, isResp = message.ThreadSubject(subject, false)
+			subjectBase = ThreadKey(a.Name, subjectBase)
 		}
 		if len(refids) > 0 || !isResp || subjectBase == "" {
 			m.ThreadID = m.ID
@@ -410,7 +417,7 @@ func (a *Account) AssignThreads(ctx context.Context, log mlog.Log, txOpt *bstore
 				HeaderOffset int64
 				BodyOffset   int64
 			}
-			if err := json.Unmarshal(m.ParsedBuf, &partialPart); err != nil {
+			if err := json.Unmarshal(m.Sealed.ParsedBuf, &partialPart); err != nil {
 				w.Err = fmt.Errorf("unmarshal part: %v", err)
 			} else {
 				size := partialPart.BodyOffset - partialPart.HeaderOffset
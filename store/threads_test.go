@@ -38,9 +38,9 @@ func TestThreadingUpgrade(t *testing.T) {
 
 		s = strings.ReplaceAll(s, "\n", "\r\n")
 		m := Message{
-			Size:      int64(len(s)),
-			MsgPrefix: []byte(s),
-			Received:  recv,
+			Size:     int64(len(s)),
+			Received: recv,
+			Sealed:   MessageSealed{MsgPrefix: []byte(s)},
 		}
 		err = acc.DeliverMailbox(log, "Inbox", &m, f)
 		tcheck(t, err, "deliver")
@@ -0,0 +1,213 @@
+package store
+
+import (
+	"context"
+	cryptorand "crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/totp"
+)
+
+var (
+	ErrTOTPEnabled    = errors.New("two-factor authentication already enabled")
+	ErrTOTPNotPending = errors.New("no two-factor authentication setup in progress")
+	ErrTOTPNotEnabled = errors.New("two-factor authentication not enabled")
+	ErrTOTPInvalid    = errors.New("invalid two-factor authentication code")
+)
+
+// TOTP holds the secret for time-based one-time passwords, used as second
+// factor when logging in to the web interfaces. When enabled, the account
+// password can no longer be used for IMAP and SMTP submission, app passwords
+// must be used instead. Only a single record, with ID 1, exists.
+type TOTP struct {
+	ID            int64
+	Secret        []byte
+	Enabled       bool      // Set after confirming setup with a valid code.
+	LastCounter   int64     // Last used TOTP time step, to prevent reuse of codes.
+	RecoveryCodes []string  // Bcrypt hashes of unused recovery codes.
+	Created       time.Time `bstore:"default now"`
+}
+
+// TOTPStatus returns whether two-factor authentication is enabled, and the
+// number of unused recovery codes.
+func (a *Account) TOTPStatus(ctx context.Context) (enabled bool, recoveryCodesLeft int, rerr error) {
+	t := TOTP{ID: 1}
+	err := a.DB.Get(ctx, &t)
+	if err == bstore.ErrAbsent {
+		return false, 0, nil
+	} else if err != nil {
+		return false, 0, err
+	}
+	return t.Enabled, len(t.RecoveryCodes), nil
+}
+
+// TOTPSetup starts setup of two-factor authentication, generating a new secret
+// that must be confirmed with TOTPEnable. Any earlier unconfirmed secret is
+// replaced.
+func (a *Account) TOTPSetup(ctx context.Context) (secret []byte, rerr error) {
+	rerr = a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		t := TOTP{ID: 1}
+		if err := tx.Get(&t); err == nil && t.Enabled {
+			return ErrTOTPEnabled
+		} else if err == nil {
+			if err := tx.Delete(&t); err != nil {
+				return fmt.Errorf("removing previous setup: %v", err)
+			}
+		} else if err != bstore.ErrAbsent {
+			return err
+		}
+		t = TOTP{ID: 1, Secret: totp.NewSecret(), Created: time.Now()}
+		secret = t.Secret
+		return tx.Insert(&t)
+	})
+	return
+}
+
+// TOTPEnable finishes setup of two-factor authentication started with
+// TOTPSetup, after verifying code. New recovery codes are returned, each can
+// be used once instead of a TOTP code.
+func (a *Account) TOTPEnable(ctx context.Context, code string) (recoveryCodes []string, rerr error) {
+	rerr = a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		t := TOTP{ID: 1}
+		if err := tx.Get(&t); err == bstore.ErrAbsent {
+			return ErrTOTPNotPending
+		} else if err != nil {
+			return err
+		} else if t.Enabled {
+			return ErrTOTPEnabled
+		}
+		counter, ok := totp.Verify(t.Secret, code, time.Now(), t.LastCounter)
+		if !ok {
+			return ErrTOTPInvalid
+		}
+		t.Enabled = true
+		t.LastCounter = counter
+		recoveryCodes, t.RecoveryCodes = genRecoveryCodes()
+		return tx.Update(&t)
+	})
+	return
+}
+
+// TOTPRecoveryCodesReset generates new recovery codes, invalidating the
+// previous codes.
+func (a *Account) TOTPRecoveryCodesReset(ctx context.Context) (recoveryCodes []string, rerr error) {
+	rerr = a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		t := TOTP{ID: 1}
+		if err := tx.Get(&t); err == bstore.ErrAbsent || err == nil && !t.Enabled {
+			return ErrTOTPNotEnabled
+		} else if err != nil {
+			return err
+		}
+		recoveryCodes, t.RecoveryCodes = genRecoveryCodes()
+		return tx.Update(&t)
+	})
+	return
+}
+
+// TOTPDisable disables two-factor authentication, removing the secret. Also
+// used by admins to reset two-factor authentication for a user who lost access.
+func (a *Account) TOTPDisable(ctx context.Context) error {
+	_, err := bstore.QueryDB[TOTP](ctx, a.DB).Delete()
+	return err
+}
+
+// TOTPCheck verifies a TOTP code, or a recovery code, which is consumed. If
+// two-factor authentication is not enabled, ErrTOTPNotEnabled is returned. For
+// an invalid code, ErrTOTPInvalid is returned.
+func (a *Account) TOTPCheck(ctx context.Context, code string) error {
+	var hashes []string
+	err := a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		t := TOTP{ID: 1}
+		if err := tx.Get(&t); err == bstore.ErrAbsent || err == nil && !t.Enabled {
+			return ErrTOTPNotEnabled
+		} else if err != nil {
+			return err
+		}
+
+		if counter, ok := totp.Verify(t.Secret, code, time.Now(), t.LastCounter); ok {
+			t.LastCounter = counter
+			return tx.Update(&t)
+		}
+		hashes = t.RecoveryCodes
+		return ErrTOTPInvalid
+	})
+	if err != ErrTOTPInvalid {
+		return err
+	}
+
+	// Recovery codes are compared outside the transaction, bcrypt is slow.
+	i := slices.IndexFunc(hashes, func(h string) bool { return recoveryCodeMatch(h, code) })
+	if i < 0 {
+		return ErrTOTPInvalid
+	}
+	return a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		t := TOTP{ID: 1}
+		if err := tx.Get(&t); err == bstore.ErrAbsent || err == nil && !t.Enabled {
+			return ErrTOTPNotEnabled
+		} else if err != nil {
+			return err
+		}
+		// The code may have been used in the mean time.
+		j := slices.Index(t.RecoveryCodes, hashes[i])
+		if j < 0 {
+			return ErrTOTPInvalid
+		}
+		t.RecoveryCodes = slices.Delete(t.RecoveryCodes, j, j+1)
+		return tx.Update(&t)
+	})
+}
+
+// TOTPEnabledTx returns whether two-factor authentication is enabled for the
+// account. If so, the account password must not be accepted for IMAP and SMTP
+// submission.
+func TOTPEnabledTx(tx *bstore.Tx) (bool, error) {
+	t := TOTP{ID: 1}
+	err := tx.Get(&t)
+	if err == bstore.ErrAbsent {
+		return false, nil
+	}
+	return t.Enabled, err
+}
+
+// genRecoveryCodes returns 10 new random recovery codes, and their hashes for
+// storage.
+func genRecoveryCodes() (codes, hashes []string) {
+	for i := 0; i < 10; i++ {
+		buf := make([]byte, 5)
+		if _, err := cryptorand.Read(buf); err != nil {
+			panic(fmt.Sprintf("reading random bytes: %v", err))
+		}
+		code := hex.EncodeToString(buf)
+		codes = append(codes, code)
+		hashes = append(hashes, recoveryCodeHash(code))
+	}
+	return
+}
+
+// recoveryCodeHash returns a bcrypt hash of a recovery code. Recovery codes have
+// only 40 bits of entropy, a slow salted hash makes it impractical to find them
+// from a copy of the database.
+func recoveryCodeHash(code string) string {
+	h, err := bcrypt.GenerateFromPassword([]byte(recoveryCodeNormalize(code)), bcrypt.DefaultCost)
+	if err != nil {
+		panic(fmt.Sprintf("hashing recovery code: %v", err))
+	}
+	return string(h)
+}
+
+func recoveryCodeMatch(hash, code string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(recoveryCodeNormalize(code))) == nil
+}
+
+func recoveryCodeNormalize(code string) string {
+	return strings.ToLower(strings.ReplaceAll(code, " ", ""))
+}
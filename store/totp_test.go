@@ -0,0 +1,165 @@
+package store
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/totp"
+)
+
+func TestTOTP(t *testing.T) {
+	log := mlog.New("store", nil)
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	acc, err := OpenAccount(log, "mjl")
+	tcheck(t, err, "open account")
+	defer func() {
+		err = acc.Close()
+		tcheck(t, err, "closing account")
+		acc.CheckClosed()
+	}()
+
+	err = acc.SetPassword(log, "testtest")
+	tcheck(t, err, "set password")
+
+	xneedErr := func(err, expErr error) {
+		t.Helper()
+		if !errors.Is(err, expErr) {
+			t.Fatalf("got err %v, expected %v", err, expErr)
+		}
+	}
+
+	_, err = acc.TOTPEnable(ctxbg, "123456")
+	xneedErr(err, ErrTOTPNotPending)
+	err = acc.TOTPCheck(ctxbg, "123456")
+	xneedErr(err, ErrTOTPNotEnabled)
+
+	secret, err := acc.TOTPSetup(ctxbg)
+	tcheck(t, err, "setup totp")
+	counter := totp.Counter(time.Now())
+
+	// Not enabled yet, password works for IMAP.
+	a, err := OpenEmailAuthProtocol(log, "mjl@mox.example", "testtest", "imap", nil)
+	tcheck(t, err, "imap login before enabling totp")
+	err = a.Close()
+	tcheck(t, err, "closing account")
+
+	_, err = acc.TOTPEnable(ctxbg, "000000x")
+	xneedErr(err, ErrTOTPInvalid)
+	// Use the previous time step, so the current time step can be used below.
+	recoveryCodes, err := acc.TOTPEnable(ctxbg, totp.Code(secret, counter-1))
+	tcheck(t, err, "enable totp")
+	if len(recoveryCodes) != 10 {
+		t.Fatalf("got %d recovery codes, expected 10", len(recoveryCodes))
+	}
+	_, err = acc.TOTPSetup(ctxbg)
+	xneedErr(err, ErrTOTPEnabled)
+
+	enabled, left, err := acc.TOTPStatus(ctxbg)
+	tcheck(t, err, "totp status")
+	if !enabled || left != 10 {
+		t.Fatalf("got status enabled %v, recovery codes left %d, expected true, 10", enabled, left)
+	}
+
+	code := totp.Code(secret, counter)
+	err = acc.TOTPCheck(ctxbg, code)
+	tcheck(t, err, "check code")
+	err = acc.TOTPCheck(ctxbg, code)
+	xneedErr(err, ErrTOTPInvalid) // Reused.
+
+	err = acc.TOTPCheck(ctxbg, recoveryCodes[0])
+	tcheck(t, err, "check recovery code")
+	err = acc.TOTPCheck(ctxbg, recoveryCodes[0])
+	xneedErr(err, ErrTOTPInvalid) // Reused.
+	_, left, err = acc.TOTPStatus(ctxbg)
+	tcheck(t, err, "totp status")
+	if left != 9 {
+		t.Fatalf("got %d recovery codes left, expected 9", left)
+	}
+
+	// Codes that are not recovery codes are rejected, without using up a recovery code.
+	err = acc.TOTPCheck(ctxbg, "0123456789")
+	xneedErr(err, ErrTOTPInvalid)
+	err = acc.TOTPCheck(ctxbg, recoveryCodes[1][:9])
+	xneedErr(err, ErrTOTPInvalid)
+	_, left, err = acc.TOTPStatus(ctxbg)
+	tcheck(t, err, "totp status")
+	if left != 9 {
+		t.Fatalf("got %d recovery codes left after wrong codes, expected 9", left)
+	}
+
+	// Recovery codes are accepted with spaces and in upper case.
+	err = acc.TOTPCheck(ctxbg, strings.ToUpper(recoveryCodes[1][:5])+" "+strings.ToUpper(recoveryCodes[1][5:]))
+	tcheck(t, err, "check recovery code with spaces and upper case")
+	err = acc.TOTPCheck(ctxbg, recoveryCodes[1])
+	xneedErr(err, ErrTOTPInvalid) // Reused.
+
+	// A recovery code used concurrently is accepted only once.
+	errc := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			errc <- acc.TOTPCheck(ctxbg, recoveryCodes[2])
+		}()
+	}
+	var nok int
+	for i := 0; i < 2; i++ {
+		if err := <-errc; err == nil {
+			nok++
+		} else {
+			xneedErr(err, ErrTOTPInvalid)
+		}
+	}
+	if nok != 1 {
+		t.Fatalf("concurrent use of recovery code succeeded %d times, expected 1", nok)
+	}
+	_, left, err = acc.TOTPStatus(ctxbg)
+	tcheck(t, err, "totp status")
+	if left != 7 {
+		t.Fatalf("got %d recovery codes left, expected 7", left)
+	}
+
+	newCodes, err := acc.TOTPRecoveryCodesReset(ctxbg)
+	tcheck(t, err, "reset recovery codes")
+	err = acc.TOTPCheck(ctxbg, recoveryCodes[1])
+	xneedErr(err, ErrTOTPInvalid)
+	err = acc.TOTPCheck(ctxbg, newCodes[0])
+	tcheck(t, err, "check new recovery code")
+
+	// With totp enabled, the account password is refused for IMAP/SMTP, but still
+	// works for web logins (which check the totp code separately). App passwords
+	// still work.
+	_, err = OpenEmailAuthProtocol(log, "mjl@mox.example", "testtest", "imap", nil)
+	xneedErr(err, ErrUnknownCredentials)
+	a, err = OpenEmailAuth(log, "mjl@mox.example", "testtest")
+	tcheck(t, err, "web login with totp enabled")
+	err = a.Close()
+	tcheck(t, err, "closing account")
+	_, pw, err := acc.AppPasswordAdd(ctxbg, "phone", nil, nil)
+	tcheck(t, err, "add app password")
+	a, err = OpenEmailAuthProtocol(log, "mjl@mox.example", pw, "smtp", net.ParseIP("127.0.0.1"))
+	tcheck(t, err, "smtp login with app password")
+	err = a.Close()
+	tcheck(t, err, "closing account")
+
+	err = acc.TOTPDisable(ctxbg)
+	tcheck(t, err, "disable totp")
+	enabled, _, err = acc.TOTPStatus(ctxbg)
+	tcheck(t, err, "totp status")
+	if enabled {
+		t.Fatalf("totp still enabled after disable")
+	}
+	err = acc.TOTPCheck(ctxbg, newCodes[1])
+	xneedErr(err, ErrTOTPNotEnabled)
+	a, err = OpenEmailAuthProtocol(log, "mjl@mox.example", "testtest", "imap", nil)
+	tcheck(t, err, "imap login after disabling totp")
+	err = a.Close()
+	tcheck(t, err, "closing account")
+}
@@ -4,14 +4,19 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"runtime/debug"
+	"sync"
+	"time"
 
 	"github.com/mjl-/bstore"
 
 	"github.com/mjl-/mox/config"
 	"github.com/mjl-/mox/junk"
+	"github.com/mjl-/mox/metrics"
 	"github.com/mjl-/mox/mlog"
 	"github.com/mjl-/mox/mox-"
 )
@@ -45,14 +50,114 @@ func (a *Account) OpenJunkFilter(ctx context.Context, log mlog.Log) (*junk.Filte
 	return f, jf, err
 }
 
+// The shared junk filter is trained with messages from accounts with
+// ContributeShared set in their junk filter configuration. It is a single
+// database for all accounts, so access is serialized with sharedJunkLock.
+var sharedJunkLock sync.Mutex
+
+// sharedJunkParams are the parameters of the shared junk filter, the same as for
+// new accounts.
+var sharedJunkParams = junk.Params{
+	Onegrams:    true,
+	MaxPower:    .01,
+	TopWords:    10,
+	IgnoreWords: .1,
+	RareWords:   2,
+}
+
+// openSharedJunkFilter opens the shared junk filter, creating it if it does not
+// exist and create is set. If it does not exist and create is not set,
+// ErrNoJunkFilter is returned. The shared junk filter is locked until the returned
+// close function is called, which saves and closes the filter.
+func openSharedJunkFilter(ctx context.Context, log mlog.Log, create bool) (*junk.Filter, func() error, error) {
+	dbPath := mox.DataDirPath(SharedJunkFilterFiles[0])
+	bloomPath := mox.DataDirPath(SharedJunkFilterFiles[1])
+
+	sharedJunkLock.Lock()
+	var f *junk.Filter
+	var err error
+	if _, xerr := os.Stat(dbPath); xerr != nil && os.IsNotExist(xerr) {
+		if !create {
+			sharedJunkLock.Unlock()
+			return nil, nil, ErrNoJunkFilter
+		}
+		f, err = junk.NewFilter(ctx, log, sharedJunkParams, dbPath, bloomPath)
+	} else {
+		f, err = junk.OpenFilter(ctx, log, sharedJunkParams, dbPath, bloomPath, false)
+	}
+	if err != nil {
+		sharedJunkLock.Unlock()
+		return nil, nil, fmt.Errorf("open shared junk filter: %w", err)
+	}
+	close := func() error {
+		defer sharedJunkLock.Unlock()
+		return f.Close()
+	}
+	return f, close, nil
+}
+
+// SharedJunkFilterFiles are the database and bloom filter files of the shared
+// junk filter in the data directory.
+var SharedJunkFilterFiles = []string{"junkfilter-shared.db", "junkfilter-shared.bloom"}
+
+// SharedJunkFilterBackup calls fn with the database of the shared junk filter,
+// while the shared junk filter is locked, e.g. for making a backup. If the shared
+// junk filter does not exist, fn is not called.
+func SharedJunkFilterBackup(ctx context.Context, log mlog.Log, fn func(db *bstore.DB)) error {
+	f, close, err := openSharedJunkFilter(ctx, log, false)
+	if err != nil && errors.Is(err, ErrNoJunkFilter) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	fn(f.DB())
+	return close()
+}
+
+// ClassifyShared classifies a message with the shared junk filter, and combines
+// the spam probability with prob from the junk filter of an account, with weight
+// for the shared probability. If the junk filter of the account did not find any
+// significant words, the shared probability is returned. If the shared junk filter
+// does not exist yet, prob is returned.
+func ClassifyShared(ctx context.Context, log mlog.Log, weight, prob float64, significant int, r io.ReaderAt, size int64) (float64, error) {
+	f, close, err := openSharedJunkFilter(ctx, log, false)
+	if err != nil && errors.Is(err, ErrNoJunkFilter) {
+		return prob, nil
+	} else if err != nil {
+		return prob, err
+	}
+	defer func() {
+		err := close()
+		log.Check(err, "closing shared junk filter")
+	}()
+
+	sharedProb, _, nham, nspam, err := f.ClassifyMessageReader(ctx, r, size)
+	if err != nil {
+		return prob, fmt.Errorf("classifying with shared junk filter: %w", err)
+	}
+	combined := (1-weight)*prob + weight*sharedProb
+	if significant == 0 {
+		combined = sharedProb
+	} else if nham+nspam == 0 {
+		combined = prob
+	}
+	log.Debug("combined junk probability with shared junk filter",
+		slog.Float64("prob", prob),
+		slog.Float64("sharedprob", sharedProb),
+		slog.Float64("combined", combined))
+	return combined, nil
+}
+
 // RetrainMessages (un)trains messages, if relevant given their flags. Updates
-// m.TrainedJunk after retraining.
+// m.TrainedJunk after retraining. If the account contributes to the shared junk
+// filter, it is trained as well.
 func (a *Account) RetrainMessages(ctx context.Context, log mlog.Log, tx *bstore.Tx, msgs []Message, absentOK bool) (rerr error) {
 	if len(msgs) == 0 {
 		return nil
 	}
 
-	var jf *junk.Filter
+	var jf, shared *junk.Filter
+	var jfConf *config.JunkFilter
 
 	for i := range msgs {
 		if !msgs[i].NeedsTraining() {
@@ -62,7 +167,7 @@ func (a *Account) RetrainMessages(ctx context.Context, log mlog.Log, tx *bstore.
 		// Lazy open the junk filter.
 		if jf == nil {
 			var err error
-			jf, _, err = a.OpenJunkFilter(ctx, log)
+			jf, jfConf, err = a.OpenJunkFilter(ctx, log)
 			if err != nil && errors.Is(err, ErrNoJunkFilter) {
 				// No junk filter configured. Nothing more to do.
 				return nil
@@ -78,16 +183,158 @@ func (a *Account) RetrainMessages(ctx context.Context, log mlog.Log, tx *bstore.
 				}
 			}()
 		}
-		if err := a.RetrainMessage(ctx, log, tx, jf, &msgs[i], absentOK); err != nil {
+		// Lazy open the shared junk filter, for training or for untraining a message
+		// trained while the account contributed.
+		if shared == nil && (jfConf.ContributeShared || msgs[i].TrainedShared) {
+			var close func() error
+			var err error
+			shared, close, err = openSharedJunkFilter(ctx, log, true)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				err := close()
+				if rerr == nil {
+					rerr = err
+				}
+			}()
+		}
+		if err := a.retrainMessage(ctx, log, tx, jf, shared, jfConf.ContributeShared, &msgs[i], absentOK); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// RetrainFlagChanges (un)trains messages whose junk/nonjunk flags were just
+// changed, e.g. by an IMAP client setting $Junk or $NotJunk, or by moving
+// messages to/from the Junk mailbox with automatic junk flags. If the account has
+// DelayFlagTraining set, the messages are left for RetrainBacklog.
+func (a *Account) RetrainFlagChanges(ctx context.Context, log mlog.Log, tx *bstore.Tx, msgs []Message) error {
+	if conf, _ := a.Conf(); conf.JunkFilter != nil && conf.JunkFilter.DelayFlagTraining {
+		return nil
+	}
+	return a.RetrainMessages(ctx, log, tx, msgs, false)
+}
+
+// RetrainBacklog (un)trains all messages whose junk/nonjunk flags changed since
+// they were last trained, e.g. because flag training is delayed for the account,
+// or because the junk filter was not enabled when the flags changed. Messages are
+// retrained in batches, each with the account write lock held. Returns the number
+// of retrained messages.
+func (a *Account) RetrainBacklog(ctx context.Context, log mlog.Log) (int, error) {
+	if conf, _ := a.Conf(); conf.JunkFilter == nil {
+		return 0, ErrNoJunkFilter
+	}
+
+	var ids []int64
+	err := a.DB.Read(ctx, func(tx *bstore.Tx) error {
+		q := bstore.QueryTx[Message](tx)
+		q.FilterEqual("Expunged", false)
+		return q.ForEach(func(m Message) error {
+			if m.NeedsTraining() {
+				ids = append(ids, m.ID)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, fmt.Errorf("listing messages: %v", err)
+	}
+
+	var trained int
+	for len(ids) > 0 {
+		batch := ids[:min(len(ids), 100)]
+		ids = ids[len(batch):]
+
+		var msgs []Message
+		a.WithWLock(func() {
+			err = a.DB.Write(ctx, func(tx *bstore.Tx) error {
+				msgs = nil
+				for _, id := range batch {
+					m := Message{ID: id}
+					if err := tx.Get(&m); err == bstore.ErrAbsent {
+						continue
+					} else if err != nil {
+						return err
+					}
+					if !m.Expunged && m.NeedsTraining() {
+						msgs = append(msgs, m)
+					}
+				}
+				return a.RetrainMessages(ctx, log, tx, msgs, false)
+			})
+		})
+		if err != nil {
+			return trained, fmt.Errorf("retraining messages: %v", err)
+		}
+		trained += len(msgs)
+	}
+	if trained > 0 {
+		log.Info("retrained backlog of messages with changed junk flags", slog.String("account", a.Name), slog.Int("trained", trained))
+	}
+	return trained, nil
+}
+
+// StartRetrainBacklog periodically retrains the backlog of messages with changed
+// junk flags for accounts with delayed flag training.
+func StartRetrainBacklog() {
+	log := mlog.New("store", nil)
+
+	go func() {
+		defer func() {
+			x := recover()
+			if x != nil {
+				log.Error("recover from panic", slog.Any("panic", x))
+				debug.PrintStack()
+				metrics.PanicInc(metrics.Store)
+			}
+		}()
+
+		for {
+			select {
+			case <-mox.Shutdown.Done():
+				return
+			case <-time.After(24 * time.Hour):
+			}
+
+			for _, accName := range mox.Conf.Accounts() {
+				if conf, ok := mox.Conf.Account(accName); !ok || conf.JunkFilter == nil || !conf.JunkFilter.DelayFlagTraining {
+					continue
+				}
+				retrainBacklogAccount(log, accName)
+				if mox.Shutdown.Err() != nil {
+					return
+				}
+			}
+		}
+	}()
+}
+
+func retrainBacklogAccount(log mlog.Log, accName string) {
+	acc, err := OpenAccount(log, accName)
+	if err != nil {
+		log.Errorx("open account for retraining backlog", err, slog.String("account", accName))
+		return
+	}
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account after retraining backlog")
+	}()
+	_, err = acc.RetrainBacklog(mox.Shutdown, log)
+	log.Check(err, "retraining backlog", slog.String("account", accName))
+}
+
 // RetrainMessage untrains and/or trains a message, if relevant given m.TrainedJunk
-// and m.Junk/m.Notjunk. Updates m.TrainedJunk after retraining.
+// and m.Junk/m.Notjunk. Updates m.TrainedJunk after retraining. The shared junk
+// filter is not updated, use RetrainMessages for that.
 func (a *Account) RetrainMessage(ctx context.Context, log mlog.Log, tx *bstore.Tx, jf *junk.Filter, m *Message, absentOK bool) error {
+	return a.retrainMessage(ctx, log, tx, jf, nil, false, m, absentOK)
+}
+
+// retrainMessage is like RetrainMessage, and also untrains the message from the
+// shared junk filter if it was trained there, and trains it if contribute is set.
+func (a *Account) retrainMessage(ctx context.Context, log mlog.Log, tx *bstore.Tx, jf, shared *junk.Filter, contribute bool, m *Message, absentOK bool) error {
 	untrain := m.TrainedJunk != nil
 	untrainJunk := untrain && *m.TrainedJunk
 	train := m.Junk || m.Notjunk && !(m.Junk && m.Notjunk)
@@ -121,12 +368,28 @@ func (a *Account) RetrainMessage(ctx context.Context, log mlog.Log, tx *bstore.T
 		return nil
 	}
 
+	// Words for the shared junk filter, parsed with its own parameters if needed.
+	sharedWords := words
+	if shared != nil && (untrain && m.TrainedShared || train && contribute) && shared.Params != jf.Params {
+		sharedWords, err = shared.ParseMessage(p)
+		if err != nil {
+			log.Errorx("parsing message for updating shared junk filter", err, slog.Any("parse", ""))
+			return nil
+		}
+	}
+
 	if untrain {
 		err := jf.Untrain(ctx, !untrainJunk, words)
 		if err != nil {
 			return err
 		}
 		m.TrainedJunk = nil
+		if m.TrainedShared && shared != nil {
+			if err := shared.Untrain(ctx, !untrainJunk, sharedWords); err != nil {
+				return err
+			}
+		}
+		m.TrainedShared = false
 	}
 	if train {
 		err := jf.Train(ctx, !trainJunk, words)
@@ -134,6 +397,12 @@ func (a *Account) RetrainMessage(ctx context.Context, log mlog.Log, tx *bstore.T
 			return err
 		}
 		m.TrainedJunk = &trainJunk
+		if contribute && shared != nil {
+			if err := shared.Train(ctx, !trainJunk, sharedWords); err != nil {
+				return err
+			}
+			m.TrainedShared = true
+		}
 	}
 	if err := tx.Update(m); err != nil && (!absentOK || err != bstore.ErrAbsent) {
 		return err
@@ -168,3 +437,17 @@ func (a *Account) TrainMessage(ctx context.Context, log mlog.Log, jf *junk.Filte
 
 	return true, jf.Train(ctx, m.Notjunk, words)
 }
+
+// TrainSharedJunk trains the shared junk filter with a message as junk, creating
+// the shared junk filter if it does not exist. Used for messages to spamtraps.
+func TrainSharedJunk(ctx context.Context, log mlog.Log, r io.ReaderAt, size int64) error {
+	f, close, err := openSharedJunkFilter(ctx, log, true)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := close()
+		log.Check(err, "closing shared junk filter")
+	}()
+	return f.TrainMessage(ctx, r, size, false)
+}
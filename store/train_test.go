@@ -0,0 +1,161 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mox-"
+)
+
+func TestRetrainBacklog(t *testing.T) {
+	log := pkglog
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	acc, err := OpenAccount(log, "mjl")
+	tcheck(t, err, "open account")
+	defer func() {
+		err = acc.Close()
+		tcheck(t, err, "closing account")
+		acc.CheckClosed()
+	}()
+	defer Switchboard()()
+
+	const msg = "Subject: train\r\n\r\ntest\r\n"
+	msgFile, err := CreateMessageTemp(log, "train-test")
+	tcheck(t, err, "create temp message")
+	defer os.Remove(msgFile.Name())
+	defer msgFile.Close()
+	_, err = msgFile.Write([]byte(msg))
+	tcheck(t, err, "write message")
+	m := Message{Received: time.Now(), Size: int64(len(msg))}
+	acc.WithWLock(func() {
+		err = acc.DeliverMailbox(log, "Inbox", &m, msgFile)
+	})
+	tcheck(t, err, "deliver message")
+
+	// Change flags as done by IMAP STORE, with delayed training the message is left
+	// for the backlog.
+	conf, _ := acc.Conf()
+	conf.JunkFilter.DelayFlagTraining = true
+	defer func() {
+		conf.JunkFilter.DelayFlagTraining = false
+	}()
+	setJunk := func(junk bool) {
+		t.Helper()
+		err := acc.DB.Write(ctxbg, func(tx *bstore.Tx) error {
+			if err := tx.Get(&m); err != nil {
+				return err
+			}
+			m.Junk = junk
+			m.Notjunk = !junk
+			if err := tx.Update(&m); err != nil {
+				return err
+			}
+			return acc.RetrainFlagChanges(ctxbg, log, tx, []Message{m})
+		})
+		tcheck(t, err, "set junk flags")
+		err = acc.DB.Get(ctxbg, &m)
+		tcheck(t, err, "get message")
+	}
+	setJunk(true)
+	tcompare(t, m.TrainedJunk == nil, true)
+
+	n, err := acc.RetrainBacklog(ctxbg, log)
+	tcheck(t, err, "retrain backlog")
+	tcompare(t, n, 1)
+	err = acc.DB.Get(ctxbg, &m)
+	tcheck(t, err, "get message")
+	tcompare(t, m.TrainedJunk != nil && *m.TrainedJunk, true)
+
+	n, err = acc.RetrainBacklog(ctxbg, log)
+	tcheck(t, err, "retrain backlog")
+	tcompare(t, n, 0)
+
+	// Without delay, flag changes are trained immediately.
+	conf.JunkFilter.DelayFlagTraining = false
+	setJunk(false)
+	tcompare(t, m.TrainedJunk != nil && !*m.TrainedJunk, true)
+	n, err = acc.RetrainBacklog(ctxbg, log)
+	tcheck(t, err, "retrain backlog")
+	tcompare(t, n, 0)
+}
+
+func TestSharedJunkFilter(t *testing.T) {
+	log := pkglog
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	acc, err := OpenAccount(log, "mjl")
+	tcheck(t, err, "open account")
+	defer func() {
+		err = acc.Close()
+		tcheck(t, err, "closing account")
+		acc.CheckClosed()
+	}()
+	defer Switchboard()()
+
+	const msg = "Subject: cheap pills\r\n\r\nbuy cheap pills now\r\n"
+	msgFile, err := CreateMessageTemp(log, "train-test")
+	tcheck(t, err, "create temp message")
+	defer os.Remove(msgFile.Name())
+	defer msgFile.Close()
+	_, err = msgFile.Write([]byte(msg))
+	tcheck(t, err, "write message")
+	m := Message{Received: time.Now(), Size: int64(len(msg))}
+	acc.WithWLock(func() {
+		err = acc.DeliverMailbox(log, "Inbox", &m, msgFile)
+	})
+	tcheck(t, err, "deliver message")
+
+	// No shared junk filter yet, the account probability is used.
+	p, err := ClassifyShared(ctxbg, log, 0.5, 0.2, 1, msgFile, m.Size)
+	tcheck(t, err, "classify without shared junk filter")
+	tcompare(t, p, 0.2)
+
+	conf, _ := acc.Conf()
+	conf.JunkFilter.ContributeShared = true
+	defer func() {
+		conf.JunkFilter.ContributeShared = false
+	}()
+	setFlags := func(junk, notjunk bool) {
+		t.Helper()
+		err := acc.DB.Write(ctxbg, func(tx *bstore.Tx) error {
+			if err := tx.Get(&m); err != nil {
+				return err
+			}
+			m.Junk = junk
+			m.Notjunk = notjunk
+			if err := tx.Update(&m); err != nil {
+				return err
+			}
+			return acc.RetrainFlagChanges(ctxbg, log, tx, []Message{m})
+		})
+		tcheck(t, err, "set junk flags")
+		err = acc.DB.Get(ctxbg, &m)
+		tcheck(t, err, "get message")
+	}
+	setFlags(true, false)
+	tcompare(t, m.TrainedShared, true)
+	_, err = os.Stat(filepath.FromSlash("../testdata/store/data/" + SharedJunkFilterFiles[0]))
+	tcheck(t, err, "stat shared junk filter")
+
+	// Without significant words in the account junk filter, the shared probability is
+	// used as is.
+	p, err = ClassifyShared(ctxbg, log, 0.5, 0.2, 0, msgFile, m.Size)
+	tcheck(t, err, "classify with shared junk filter")
+	if p <= 0.5 {
+		t.Fatalf("got shared probability %v, expected spam", p)
+	}
+
+	// Untraining also updates the shared junk filter if the account no longer
+	// contributes.
+	conf.JunkFilter.ContributeShared = false
+	setFlags(false, false)
+	tcompare(t, m.TrainedShared, false)
+	tcompare(t, m.TrainedJunk == nil, true)
+}
@@ -0,0 +1,197 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mlog"
+)
+
+// ErrUploadOffset is returned when writing a chunk of an upload at an offset other
+// than the number of bytes received so far. Clients resume at Upload.Received.
+var ErrUploadOffset = errors.New("chunk offset does not match received size of upload")
+
+// ErrUploadSize is returned when data beyond the announced size of an upload is
+// written.
+var ErrUploadSize = errors.New("data beyond upload size")
+
+// Upload is a file uploaded through webmail, to be added as attachment or inline
+// image to a message being composed. Uploads are transferred in chunks and can be
+// resumed. They are kept with the draft message they belong to, and removed after
+// sending, or when their draft is removed.
+type Upload struct {
+	ID             int64
+	Created        time.Time `bstore:"nonzero,default now"`
+	DraftMessageID int64     `bstore:"index"` // Draft message the upload belongs to, 0 while not saved with a draft.
+	Filename       string    `bstore:"nonzero"`
+	ContentType    string    `bstore:"nonzero"` // E.g. "image/png".
+	ContentID      string    // For inline images, without <>, for referencing with "cid:".
+	Size           int64     // Total size, as announced when starting the upload.
+	Received       int64     // Bytes stored so far. The upload is complete when equal to Size.
+}
+
+// Complete returns whether all data of the upload has been received.
+func (u Upload) Complete() bool {
+	return u.Received == u.Size
+}
+
+// UploadPath returns the file system path for the data of an upload.
+func (a *Account) UploadPath(id int64) string {
+	return filepath.Join(a.Dir, "upload", fmt.Sprintf("%d", id))
+}
+
+// UploadAdd starts a new upload, creating an empty file for its data. Stale
+// uploads are cleaned up first: those without draft message created more than a
+// day ago, and those whose draft message has been removed.
+func (a *Account) UploadAdd(ctx context.Context, log mlog.Log, u *Upload) error {
+	var remove []Upload
+	err := a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		var err error
+		remove, err = uploadsStale(tx)
+		if err != nil {
+			return err
+		}
+		for _, xu := range remove {
+			if err := tx.Delete(&xu); err != nil {
+				return fmt.Errorf("removing stale upload: %v", err)
+			}
+		}
+
+		u.ID = 0
+		u.Received = 0
+		if err := tx.Insert(u); err != nil {
+			return err
+		}
+		p := a.UploadPath(u.ID)
+		os.MkdirAll(filepath.Dir(p), 0770)
+		f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0660)
+		if err != nil {
+			return fmt.Errorf("creating upload file: %v", err)
+		}
+		return f.Close()
+	})
+	a.UploadsRemoveFiles(log, remove)
+	return err
+}
+
+// uploadsStale returns uploads that are no longer needed.
+func uploadsStale(tx *bstore.Tx) ([]Upload, error) {
+	var l []Upload
+	old := time.Now().Add(-24 * time.Hour)
+	err := bstore.QueryTx[Upload](tx).ForEach(func(u Upload) error {
+		if u.DraftMessageID == 0 {
+			if u.Created.Before(old) {
+				l = append(l, u)
+			}
+			return nil
+		}
+		m := Message{ID: u.DraftMessageID}
+		if err := tx.Get(&m); err == bstore.ErrAbsent || err == nil && m.Expunged {
+			l = append(l, u)
+		} else if err != nil {
+			return fmt.Errorf("get draft message for upload: %v", err)
+		}
+		return nil
+	})
+	return l, err
+}
+
+// UploadsRemoveFiles removes the files for uploads removed from the database.
+func (a *Account) UploadsRemoveFiles(log mlog.Log, l []Upload) {
+	for _, u := range l {
+		err := os.Remove(a.UploadPath(u.ID))
+		log.Check(err, "removing upload file", slog.Int64("uploadid", u.ID))
+	}
+}
+
+// UploadWrite stores a chunk of data for an upload. Offset must be the number of
+// bytes received so far, otherwise ErrUploadOffset is returned.
+func (a *Account) UploadWrite(ctx context.Context, id, offset int64, data []byte) (Upload, error) {
+	var u Upload
+	err := a.DB.Write(ctx, func(tx *bstore.Tx) error {
+		u = Upload{ID: id}
+		if err := tx.Get(&u); err != nil {
+			return err
+		}
+		if offset != u.Received {
+			return ErrUploadOffset
+		}
+		if int64(len(data)) > u.Size-u.Received {
+			return ErrUploadSize
+		}
+
+		f, err := os.OpenFile(a.UploadPath(u.ID), os.O_WRONLY, 0660)
+		if err != nil {
+			return fmt.Errorf("open upload file: %v", err)
+		}
+		defer f.Close()
+		if _, err := f.WriteAt(data, offset); err != nil {
+			return fmt.Errorf("writing upload data: %v", err)
+		}
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("closing upload file: %v", err)
+		}
+		u.Received += int64(len(data))
+		return tx.Update(&u)
+	})
+	return u, err
+}
+
+// Uploads returns the uploads for a draft message.
+func (a *Account) Uploads(ctx context.Context, draftMessageID int64) ([]Upload, error) {
+	if draftMessageID == 0 {
+		return nil, nil
+	}
+	q := bstore.QueryDB[Upload](ctx, a.DB)
+	q.FilterNonzero(Upload{DraftMessageID: draftMessageID})
+	q.SortAsc("ID")
+	return q.List()
+}
+
+// UploadsLinkTx sets the draft message for uploads. Other uploads of the previous
+// draft message are removed, their files must be removed with UploadsRemoveFiles
+// after the transaction is committed.
+func (a *Account) UploadsLinkTx(tx *bstore.Tx, ids []int64, prevDraftMessageID, draftMessageID int64) ([]Upload, error) {
+	for _, id := range ids {
+		u := Upload{ID: id}
+		if err := tx.Get(&u); err != nil {
+			return nil, fmt.Errorf("get upload %d: %w", id, err)
+		}
+		u.DraftMessageID = draftMessageID
+		if err := tx.Update(&u); err != nil {
+			return nil, fmt.Errorf("updating upload: %v", err)
+		}
+	}
+	if prevDraftMessageID == 0 {
+		return nil, nil
+	}
+	q := bstore.QueryTx[Upload](tx)
+	q.FilterNonzero(Upload{DraftMessageID: prevDraftMessageID})
+	l, err := q.List()
+	if err != nil {
+		return nil, fmt.Errorf("listing uploads of previous draft: %v", err)
+	}
+	for _, u := range l {
+		if err := tx.Delete(&u); err != nil {
+			return nil, fmt.Errorf("removing upload of previous draft: %v", err)
+		}
+	}
+	return l, nil
+}
+
+// UploadRemove removes an upload and its data.
+func (a *Account) UploadRemove(ctx context.Context, log mlog.Log, id int64) error {
+	u := Upload{ID: id}
+	if err := a.DB.Delete(ctx, &u); err != nil {
+		return err
+	}
+	a.UploadsRemoveFiles(log, []Upload{u})
+	return nil
+}
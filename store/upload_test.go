@@ -0,0 +1,111 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+)
+
+func TestUpload(t *testing.T) {
+	log := mlog.New("store", nil)
+	os.RemoveAll("../testdata/store/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/store/mox.conf")
+	mox.MustLoadConfig(true, false)
+	acc, err := OpenAccount(log, "mjl")
+	tcheck(t, err, "open account")
+	defer func() {
+		err = acc.Close()
+		tcheck(t, err, "closing account")
+		acc.CheckClosed()
+	}()
+	defer Switchboard()()
+
+	u := Upload{Filename: "test.txt", ContentType: "text/plain", Size: 6}
+	err = acc.UploadAdd(ctxbg, log, &u)
+	tcheck(t, err, "add upload")
+
+	_, err = acc.UploadWrite(ctxbg, u.ID, 1, []byte("abc"))
+	if !errors.Is(err, ErrUploadOffset) {
+		t.Fatalf("got err %v, expected ErrUploadOffset", err)
+	}
+	xu, err := acc.UploadWrite(ctxbg, u.ID, 0, []byte("abc"))
+	tcheck(t, err, "write chunk")
+	tcompare(t, xu.Received, int64(3))
+	tcompare(t, xu.Complete(), false)
+	_, err = acc.UploadWrite(ctxbg, u.ID, 3, []byte("defg"))
+	if !errors.Is(err, ErrUploadSize) {
+		t.Fatalf("got err %v, expected ErrUploadSize", err)
+	}
+	// Resume at received offset.
+	xu, err = acc.UploadWrite(ctxbg, u.ID, xu.Received, []byte("def"))
+	tcheck(t, err, "write chunk")
+	tcompare(t, xu.Complete(), true)
+	buf, err := os.ReadFile(acc.UploadPath(u.ID))
+	tcheck(t, err, "read upload file")
+	tcompare(t, string(buf), "abcdef")
+
+	// Link to a draft message, then to its replacement without the upload.
+	deliver := func() Message {
+		t.Helper()
+		msgFile, err := CreateMessageTemp(log, "upload-test")
+		tcheck(t, err, "create temp message")
+		defer os.Remove(msgFile.Name())
+		defer msgFile.Close()
+		msg := "Subject: draft\r\n\r\ndraft\r\n"
+		_, err = msgFile.Write([]byte(msg))
+		tcheck(t, err, "write message")
+		m := Message{Size: int64(len(msg))}
+		acc.WithWLock(func() {
+			err = acc.DeliverMailbox(log, "Drafts", &m, msgFile)
+		})
+		tcheck(t, err, "deliver")
+		return m
+	}
+	d1 := deliver()
+	err = acc.DB.Write(ctxbg, func(tx *bstore.Tx) error {
+		_, err := acc.UploadsLinkTx(tx, []int64{u.ID}, 0, d1.ID)
+		return err
+	})
+	tcheck(t, err, "link upload")
+	l, err := acc.Uploads(ctxbg, d1.ID)
+	tcheck(t, err, "list uploads")
+	tcompare(t, len(l), 1)
+
+	d2 := deliver()
+	var removed []Upload
+	err = acc.DB.Write(ctxbg, func(tx *bstore.Tx) error {
+		removed, err = acc.UploadsLinkTx(tx, nil, d1.ID, d2.ID)
+		return err
+	})
+	tcheck(t, err, "link uploads to new draft")
+	tcompare(t, len(removed), 1)
+	acc.UploadsRemoveFiles(log, removed)
+	if _, err := os.Stat(acc.UploadPath(u.ID)); err == nil {
+		t.Fatalf("upload file still present after removal")
+	}
+
+	// Uploads for removed drafts are cleaned up when adding a new upload.
+	u2 := Upload{Filename: "test.txt", ContentType: "text/plain", Size: 1}
+	err = acc.UploadAdd(ctxbg, log, &u2)
+	tcheck(t, err, "add upload")
+	err = acc.DB.Write(ctxbg, func(tx *bstore.Tx) error {
+		_, err := acc.UploadsLinkTx(tx, []int64{u2.ID}, 0, d2.ID+1000) // Draft does not exist.
+		return err
+	})
+	tcheck(t, err, "link upload")
+	u3 := Upload{Filename: "test.txt", ContentType: "text/plain", Size: 1}
+	err = acc.UploadAdd(ctxbg, log, &u3)
+	tcheck(t, err, "add upload")
+	n, err := bstore.QueryDB[Upload](ctxbg, acc.DB).Count()
+	tcheck(t, err, "count uploads")
+	tcompare(t, n, 1)
+
+	err = acc.UploadRemove(ctxbg, log, u3.ID)
+	tcheck(t, err, "remove upload")
+}
@@ -0,0 +1,401 @@
+// Package tlsfp calculates JA3 and JA4 fingerprints of TLS clients from their
+// ClientHello message.
+//
+// Fingerprints identify the TLS implementation and its configuration, not the
+// client host. Many different hosts running the same software share a
+// fingerprint, so fingerprints can recognize e.g. botnets that use many IPs.
+//
+// JA3: https://github.com/salesforce/ja3
+// JA4: https://github.com/FoxIO-LLC/ja4/blob/main/technical_details/JA4.md
+package tlsfp
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrIncomplete  = errors.New("incomplete clienthello")
+	ErrNotTLS      = errors.New("not a tls handshake record")
+	ErrNoHello     = errors.New("handshake message is not a clienthello")
+	ErrMalformed   = errors.New("malformed clienthello")
+	ErrNotRecorded = errors.New("clienthello not recorded")
+)
+
+// Maximum size of a ClientHello we record. TLS allows handshake messages up to
+// 16MB, but real ClientHello messages are much smaller, even with post-quantum key
+// shares.
+const maxRecord = 64 * 1024
+
+// Fingerprint holds the fingerprints of a TLS ClientHello.
+type Fingerprint struct {
+	JA3 string // MD5 hash in lower-case hex of the JA3 string.
+	JA4 string // E.g. t13d1516h2_8daaf6152771_02713d6af862.
+}
+
+// Extension types used in fingerprints.
+const (
+	extServerName          = 0x0000
+	extSupportedGroups     = 0x000a
+	extECPointFormats      = 0x000b
+	extSignatureAlgorithms = 0x000d
+	extALPN                = 0x0010
+	extSupportedVersions   = 0x002b
+)
+
+// isGREASE returns whether v is a GREASE value, RFC 8701, which are ignored for
+// fingerprints because clients pick them randomly.
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+// clientHello holds the fields of a ClientHello needed for fingerprints. GREASE
+// values are left out.
+type clientHello struct {
+	version             uint16 // Legacy version in the ClientHello.
+	ciphers             []uint16
+	extensions          []uint16 // In order of the ClientHello.
+	groups              []uint16
+	pointFormats        []uint8
+	signatureAlgorithms []uint16
+	versions            []uint16 // From supported_versions extension.
+	alpn                []string
+	serverName          bool
+}
+
+// Parse parses the TLS records in buf holding a ClientHello, as sent by a TLS
+// client at the start of a connection, and returns its fingerprints.
+func Parse(buf []byte) (Fingerprint, error) {
+	msg, err := handshakeMessage(buf)
+	if err != nil {
+		return Fingerprint{}, err
+	}
+	ch, err := parseClientHello(msg)
+	if err != nil {
+		return Fingerprint{}, err
+	}
+	return Fingerprint{ch.ja3(), ch.ja4()}, nil
+}
+
+// handshakeMessage returns the first handshake message from the TLS records in
+// buf. A handshake message can be fragmented over multiple records.
+func handshakeMessage(buf []byte) ([]byte, error) {
+	var msg []byte
+	for {
+		if len(msg) >= 4 {
+			size := int(msg[1])<<16 | int(msg[2])<<8 | int(msg[3])
+			if size > maxRecord {
+				return nil, fmt.Errorf("%w: handshake message too large", ErrMalformed)
+			}
+			if len(msg) >= 4+size {
+				return msg[:4+size], nil
+			}
+		}
+		if len(buf) < 5 {
+			return nil, ErrIncomplete
+		}
+		// Content type 22 is handshake.
+		if buf[0] != 22 || buf[1] != 3 {
+			return nil, ErrNotTLS
+		}
+		size := int(binary.BigEndian.Uint16(buf[3:5]))
+		if len(buf) < 5+size {
+			return nil, ErrIncomplete
+		}
+		msg = append(msg, buf[5:5+size]...)
+		buf = buf[5+size:]
+	}
+}
+
+// reader reads big-endian fields, recording a read past the end.
+type reader struct {
+	buf []byte
+	bad bool
+}
+
+func (r *reader) bytes(n int) []byte {
+	if n > len(r.buf) {
+		r.bad = true
+		r.buf = nil
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *reader) uint8() uint8 {
+	b := r.bytes(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *reader) uint16() uint16 {
+	b := r.bytes(2)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(b)
+}
+
+// vector returns a reader for a length-prefixed field, with a length of size 1 or 2 bytes.
+func (r *reader) vector(size int) *reader {
+	var n int
+	if size == 1 {
+		n = int(r.uint8())
+	} else {
+		n = int(r.uint16())
+	}
+	b := r.bytes(n)
+	return &reader{buf: b, bad: r.bad}
+}
+
+func (r *reader) uint16s() []uint16 {
+	var l []uint16
+	for len(r.buf) >= 2 {
+		if v := r.uint16(); !isGREASE(v) {
+			l = append(l, v)
+		}
+	}
+	if len(r.buf) != 0 {
+		r.bad = true
+	}
+	return l
+}
+
+func parseClientHello(msg []byte) (ch clientHello, rerr error) {
+	// Handshake type 1 is ClientHello.
+	if msg[0] != 1 {
+		return ch, ErrNoHello
+	}
+	r := &reader{buf: msg[4:]}
+	ch.version = r.uint16()
+	r.bytes(32) // Random.
+	r.vector(1) // Session ID.
+	cr := r.vector(2)
+	ch.ciphers = cr.uint16s()
+	r.vector(1) // Compression methods.
+	if r.bad || cr.bad {
+		return ch, ErrMalformed
+	}
+	if len(r.buf) == 0 {
+		// No extensions, e.g. old SSL3 clients.
+		return ch, nil
+	}
+
+	xr := r.vector(2)
+	if r.bad || len(r.buf) != 0 {
+		return ch, ErrMalformed
+	}
+	for len(xr.buf) > 0 {
+		t := xr.uint16()
+		data := xr.vector(2)
+		if xr.bad || data.bad {
+			return ch, ErrMalformed
+		}
+		if isGREASE(t) {
+			continue
+		}
+		ch.extensions = append(ch.extensions, t)
+
+		switch t {
+		case extServerName:
+			ch.serverName = true
+		case extSupportedGroups:
+			ch.groups = data.vector(2).uint16s()
+		case extECPointFormats:
+			ch.pointFormats = data.vector(1).buf
+		case extSignatureAlgorithms:
+			ch.signatureAlgorithms = data.vector(2).uint16s()
+		case extSupportedVersions:
+			ch.versions = data.vector(1).uint16s()
+		case extALPN:
+			pr := data.vector(2)
+			for len(pr.buf) > 0 && !pr.bad {
+				if p := pr.vector(1); !p.bad {
+					ch.alpn = append(ch.alpn, string(p.buf))
+				}
+			}
+		}
+	}
+	return ch, nil
+}
+
+func joinDecimal[T uint8 | uint16](l []T) string {
+	s := make([]string, len(l))
+	for i, v := range l {
+		s[i] = fmt.Sprintf("%d", v)
+	}
+	return strings.Join(s, "-")
+}
+
+// ja3 returns the MD5 hash of "version,ciphers,extensions,groups,pointformats",
+// with values in decimal, separated by dashes.
+func (ch clientHello) ja3() string {
+	s := fmt.Sprintf("%d,%s,%s,%s,%s", ch.version, joinDecimal(ch.ciphers), joinDecimal(ch.extensions), joinDecimal(ch.groups), joinDecimal(ch.pointFormats))
+	h := md5.Sum([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+// hash12 returns the first 12 hex characters of the SHA-256 hash of s, or 12
+// zeroes for an empty s.
+func hash12(s string) string {
+	if s == "" {
+		return "000000000000"
+	}
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:6])
+}
+
+func joinHex(l []uint16) string {
+	s := make([]string, len(l))
+	for i, v := range l {
+		s[i] = fmt.Sprintf("%04x", v)
+	}
+	return strings.Join(s, ",")
+}
+
+// ja4 returns the JA4 fingerprint of the form a_b_c: a describes the protocol,
+// TLS version, server name presence, number of ciphers and extensions and the
+// first ALPN protocol, b is the hash of the sorted ciphers, and c the hash of the
+// sorted extensions and the signature algorithms.
+func (ch clientHello) ja4() string {
+	version := ch.version
+	for _, v := range ch.versions {
+		if v > version {
+			version = v
+		}
+	}
+	versions := map[uint16]string{
+		0x0304: "13",
+		0x0303: "12",
+		0x0302: "11",
+		0x0301: "10",
+		0x0300: "s3",
+		0x0002: "s2",
+	}
+	vs, ok := versions[version]
+	if !ok {
+		vs = "00"
+	}
+
+	sni := "i"
+	if ch.serverName {
+		sni = "d"
+	}
+
+	alpn := "00"
+	if len(ch.alpn) > 0 && ch.alpn[0] != "" {
+		p := ch.alpn[0]
+		isAlnum := func(c byte) bool {
+			return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+		}
+		if isAlnum(p[0]) && isAlnum(p[len(p)-1]) {
+			alpn = p[:1] + p[len(p)-1:]
+		} else {
+			x := hex.EncodeToString([]byte(p))
+			alpn = x[:1] + x[len(x)-1:]
+		}
+	}
+
+	a := fmt.Sprintf("t%s%s%02d%02d%s", vs, sni, min(len(ch.ciphers), 99), min(len(ch.extensions), 99), alpn)
+
+	ciphers := slices.Clone(ch.ciphers)
+	slices.Sort(ciphers)
+
+	var exts []uint16
+	for _, t := range ch.extensions {
+		if t != extServerName && t != extALPN {
+			exts = append(exts, t)
+		}
+	}
+	slices.Sort(exts)
+	c := joinHex(exts)
+	if c != "" && len(ch.signatureAlgorithms) > 0 {
+		c += "_" + joinHex(ch.signatureAlgorithms)
+	}
+
+	return a + "_" + hash12(joinHex(ciphers)) + "_" + hash12(c)
+}
+
+// Conn records the start of the data read from a connection, until a full TLS
+// ClientHello has been read, for calculating its fingerprint. Server connections
+// are wrapped in a Conn before being passed to tls.Server, so the fingerprint is
+// available in tls.Config.GetConfigForClient through ClientHelloInfo.Conn, and
+// after the handshake.
+type Conn struct {
+	net.Conn
+
+	buf       []byte
+	recording bool
+	done      bool
+	fp        Fingerprint
+	err       error
+}
+
+// NewConn returns a Conn recording the ClientHello read from conn.
+func NewConn(conn net.Conn) *Conn {
+	return &Conn{Conn: conn, recording: true}
+}
+
+// Read reads from the underlying connection, recording data until a full
+// ClientHello has been read.
+func (c *Conn) Read(buf []byte) (int, error) {
+	n, err := c.Conn.Read(buf)
+	if c.recording && n > 0 {
+		c.buf = append(c.buf, buf[:n]...)
+		if _, xerr := handshakeMessage(c.buf); xerr != ErrIncomplete || len(c.buf) > maxRecord {
+			c.recording = false
+		}
+	}
+	return n, err
+}
+
+// NetConn returns the underlying connection.
+func (c *Conn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// Fingerprint returns the fingerprints of the ClientHello read from the
+// connection. The fingerprint is calculated once, the recorded data is released.
+// Not safe for concurrent use with Read.
+func (c *Conn) Fingerprint() (Fingerprint, error) {
+	if c.done {
+		return c.fp, c.err
+	}
+	if c.recording && len(c.buf) == 0 {
+		return Fingerprint{}, ErrNotRecorded
+	}
+	c.done = true
+	c.recording = false
+	c.fp, c.err = Parse(c.buf)
+	c.buf = nil
+	return c.fp, c.err
+}
+
+// NewListener returns a listener that wraps accepted connections in a Conn,
+// e.g. for use with tls.NewListener.
+func NewListener(ln net.Listener) net.Listener {
+	return listener{ln}
+}
+
+type listener struct {
+	net.Listener
+}
+
+func (l listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(conn), nil
+}
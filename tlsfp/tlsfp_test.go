@@ -0,0 +1,138 @@
+package tlsfp
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+func tcompare(t *testing.T, got, exp any) {
+	t.Helper()
+	if got != exp {
+		t.Fatalf("got %v, expected %v", got, exp)
+	}
+}
+
+func vec(size int, data ...[]byte) []byte {
+	var b []byte
+	for _, d := range data {
+		b = append(b, d...)
+	}
+	if size == 1 {
+		return append([]byte{byte(len(b))}, b...)
+	}
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(b))), b...)
+}
+
+func u16(l ...uint16) []byte {
+	var b []byte
+	for _, v := range l {
+		b = binary.BigEndian.AppendUint16(b, v)
+	}
+	return b
+}
+
+func ext(t uint16, data ...[]byte) []byte {
+	return append(u16(t), vec(2, data...)...)
+}
+
+// records returns the handshake message in TLS records of at most size bytes.
+func records(msg []byte, size int) []byte {
+	var buf []byte
+	for len(msg) > 0 {
+		n := min(len(msg), size)
+		buf = append(buf, 22, 3, 1)
+		buf = append(buf, vec(2, msg[:n])...)
+		msg = msg[n:]
+	}
+	return buf
+}
+
+func TestParse(t *testing.T) {
+	body := append(u16(0x0303), make([]byte, 32)...) // Version, random.
+	body = append(body, vec(1, []byte("session"))...)
+	body = append(body, vec(2, u16(0x0a0a, 0x1301, 0xc02b))...)
+	body = append(body, vec(1, []byte{0})...)
+	body = append(body, vec(2,
+		ext(0x1a1a), // GREASE.
+		ext(extServerName, vec(2, []byte{0}, vec(2, []byte("mox.example")))),
+		ext(extSupportedGroups, vec(2, u16(0x2a2a, 0x001d, 0x0017))),
+		ext(extECPointFormats, vec(1, []byte{0})),
+		ext(extSignatureAlgorithms, vec(2, u16(0x0403, 0x0804))),
+		ext(extALPN, vec(2, vec(1, []byte("h2")), vec(1, []byte("http/1.1")))),
+		ext(extSupportedVersions, vec(1, u16(0x3a3a, 0x0304, 0x0303))),
+	)...)
+	msg := append([]byte{1, 0}, vec(2, body)...)
+
+	hash12 := func(s string) string {
+		h := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(h[:6])
+	}
+	ja3 := md5.Sum([]byte("771,4865-49195,0-10-11-13-16-43,29-23,0"))
+	exp := Fingerprint{
+		JA3: hex.EncodeToString(ja3[:]),
+		JA4: "t13d0206h2_" + hash12("1301,c02b") + "_" + hash12("000a,000b,000d,002b_0403,0804"),
+	}
+
+	fp, err := Parse(records(msg, 1000))
+	tcompare(t, err, nil)
+	tcompare(t, fp, exp)
+
+	// Fragmented over multiple records.
+	fp, err = Parse(records(msg, 10))
+	tcompare(t, err, nil)
+	tcompare(t, fp, exp)
+
+	buf := records(msg, 1000)
+	_, err = Parse(buf[:len(buf)-1])
+	tcompare(t, err, ErrIncomplete)
+
+	_, err = Parse([]byte("EHLO mox.example\r\n"))
+	tcompare(t, err, ErrNotTLS)
+
+	_, err = Parse(records([]byte{2, 0, 0, 0}, 1000))
+	tcompare(t, err, ErrNoHello)
+
+	_, err = Parse(records(append([]byte{1, 0, 0, 5}, 3, 3, 0, 0, 0), 1000))
+	tcompare(t, errors.Is(err, ErrMalformed), true)
+}
+
+func TestConn(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+
+	go func() {
+		config := &tls.Config{
+			ServerName: "mox.example",
+			NextProtos: []string{"imap"},
+		}
+		tls.Client(clientConn, config).Handshake()
+		clientConn.Close()
+	}()
+
+	conn := NewConn(serverConn)
+	_, err := conn.Fingerprint()
+	tcompare(t, err, ErrNotRecorded)
+
+	errBlocked := errors.New("blocked")
+	var fp Fingerprint
+	config := &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			var err error
+			fp, err = hello.Conn.(*Conn).Fingerprint()
+			tcompare(t, err, nil)
+			return nil, errBlocked
+		},
+	}
+	err = tls.Server(conn, config).Handshake()
+	tcompare(t, err, errBlocked)
+	if !strings.HasPrefix(fp.JA4, "t13d") || !strings.Contains(fp.JA4, "ip_") || len(fp.JA3) != 32 {
+		t.Fatalf("unexpected fingerprint %#v", fp)
+	}
+}
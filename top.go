@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mjl-/mox/admindb"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/queue"
+)
+
+// topSnapshot is the state of a running mox shown by "mox top", sent as JSON
+// over ctl.
+type topSnapshot struct {
+	Time        time.Time
+	Connections []mox.ConnectionCount
+	QueueTotal  int
+	Queue       []topQueueMsg // Ordered by next delivery attempt.
+	Events      []admindb.MessageEvent
+}
+
+type topQueueMsg struct {
+	ID          int64
+	Hold        bool
+	Account     string
+	From        string
+	To          string
+	Attempts    int
+	NextAttempt time.Time
+	LastError   string
+}
+
+// Kinds of message events shown by "mox top".
+var topEventKinds = []admindb.EventKind{
+	admindb.EventJunk,
+	admindb.EventDelivered,
+	admindb.EventRejected,
+	admindb.EventQuarantined,
+	admindb.EventDiscarded,
+	admindb.EventFailed,
+}
+
+// topGather returns a snapshot for "mox top", for use in the running mox.
+func topGather(ctx context.Context, maxQueue, maxEvents int) (topSnapshot, error) {
+	s := topSnapshot{Time: time.Now(), Connections: mox.Connections.Counts()}
+	var err error
+	s.QueueTotal, err = queue.Count(ctx)
+	if err != nil {
+		return s, fmt.Errorf("counting messages in queue: %v", err)
+	}
+	qml, err := queue.List(ctx, queue.Filter{Max: maxQueue}, queue.Sort{Field: "NextAttempt", Asc: true})
+	if err != nil {
+		return s, fmt.Errorf("listing queue: %v", err)
+	}
+	for _, qm := range qml {
+		s.Queue = append(s.Queue, topQueueMsg{
+			ID:          qm.ID,
+			Hold:        qm.Hold,
+			Account:     qm.SenderAccount,
+			From:        qm.Sender().LogString(),
+			To:          qm.Recipient().LogString(),
+			Attempts:    qm.Attempts,
+			NextAttempt: qm.NextAttempt,
+			LastError:   qm.LastResult().Error,
+		})
+	}
+	s.Events, err = admindb.MessageEventRecent(ctx, topEventKinds, maxEvents)
+	if err != nil {
+		return s, fmt.Errorf("listing message events: %v", err)
+	}
+	return s, nil
+}
+
+func cmdTop(c *cmd) {
+	c.params = "[-interval duration]"
+	c.help = `Show live connections, the queue and recent deliveries in a terminal.
+
+The screen is refreshed periodically with the open SMTP and IMAP connections
+per listener, the messages in the outgoing queue ordered by their next delivery
+attempt, and recent message events: deliveries to mailboxes and remote
+servers, rejections, junk filter classifications and failed deliveries. See
+"mox message trace" for the full history of a message.
+
+Keys:
+
+	j, down arrow	select next message in queue
+	k, up arrow	select previous message in queue
+	n		deliver selected message now
+	h		hold selected message, or release it if on hold
+	r		refresh now
+	q		quit
+
+Only works on unix systems, in a terminal.
+`
+	interval := 2 * time.Second
+	c.flag.DurationVar(&interval, "interval", interval, "time between refreshes")
+	args := c.Parse()
+	if len(args) != 0 {
+		c.Usage()
+	}
+	mustLoadConfig()
+
+	// Errors from ctl panic with topStop, so we can restore the terminal.
+	ctl := xctl()
+	topStop := &struct{}{}
+	ctl.x = topStop
+	ctl.log = c.log
+
+	saved, err := topStty("-g")
+	xcheckf(err, "getting terminal settings, stdin must be a terminal")
+	_, err = topStty("-icanon", "-echo", "min", "1")
+	xcheckf(err, "setting terminal to read key presses")
+	restore := func() {
+		fmt.Print("\x1b[?25h\x1b[?1049l") // Show cursor, restore screen.
+		topStty(strings.TrimSpace(saved))
+	}
+	fmt.Print("\x1b[?1049h\x1b[?25l") // Alternate screen, hide cursor.
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGTERM)
+
+	keys := make(chan string)
+	go func() {
+		buf := make([]byte, 16)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				close(keys)
+				return
+			}
+			keys <- string(buf[:n])
+		}
+	}()
+
+	var errmsg string
+	func() {
+		defer func() {
+			x := recover()
+			if x == nil {
+				return
+			}
+			if x != topStop {
+				restore()
+				panic(x)
+			}
+			errmsg = ctl.errmsg
+			if errmsg == "" {
+				errmsg = "error communicating with mox"
+			}
+		}()
+		topRun(ctl, interval, keys, sigc)
+	}()
+	restore()
+	if errmsg != "" {
+		log.Fatalf("%s", errmsg)
+	}
+}
+
+// topRun refreshes the screen and handles key presses until the user quits.
+func topRun(ctl *ctl, interval time.Duration, keys chan string, sigc chan os.Signal) {
+	var selected int64 // Queue message ID.
+	var status string  // Result of last action.
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		rows, cols := topSize()
+		// Queue and events share the space below the connections.
+		maxQueue := max((rows-10)/2, 3)
+		ctl.xwrite("top")
+		ctl.xwrite(fmt.Sprintf("%d", maxQueue))
+		ctl.xwrite(fmt.Sprintf("%d", max(rows-10-maxQueue, 3)))
+		ctl.xreadok()
+		var s topSnapshot
+		err := json.Unmarshal([]byte(ctl.xread()), &s)
+		ctl.xcheck(err, "parsing snapshot")
+
+		index := -1
+		for i, qm := range s.Queue {
+			if qm.ID == selected {
+				index = i
+			}
+		}
+		if index < 0 && len(s.Queue) > 0 {
+			index = 0
+			selected = s.Queue[0].ID
+		}
+		fmt.Print(topRender(s, index, status, rows, cols))
+
+		select {
+		case <-sigc:
+			return
+		case <-ticker.C:
+			continue
+		case k, ok := <-keys:
+			if !ok {
+				return
+			}
+			status = ""
+			switch k {
+			case "q", "Q":
+				return
+			case "j", "\x1b[B":
+				if index >= 0 && index+1 < len(s.Queue) {
+					selected = s.Queue[index+1].ID
+				}
+			case "k", "\x1b[A":
+				if index > 0 {
+					selected = s.Queue[index-1].ID
+				}
+			case "n":
+				if index >= 0 {
+					ctl.xwrite("queueschedule")
+					xctlwriteJSON(ctl, queue.Filter{IDs: []int64{selected}})
+					ctl.xwrite("yes")
+					ctl.xwrite("0s")
+					ctl.xreadok()
+					ctl.xread()
+					status = fmt.Sprintf("message %d scheduled for immediate delivery", selected)
+				}
+			case "h":
+				if index >= 0 {
+					hold := !s.Queue[index].Hold
+					ctl.xwrite("queueholdset")
+					xctlwriteJSON(ctl, queue.Filter{IDs: []int64{selected}})
+					ctl.xwrite(fmt.Sprintf("%v", hold))
+					ctl.xreadok()
+					ctl.xread()
+					if hold {
+						status = fmt.Sprintf("message %d on hold", selected)
+					} else {
+						status = fmt.Sprintf("message %d released", selected)
+					}
+				}
+			}
+		}
+	}
+}
+
+// topRender returns the escape sequences and text for drawing a snapshot. Lines
+// are truncated to the terminal width.
+func topRender(s topSnapshot, selected int, status string, rows, cols int) string {
+	var lines []string
+	add := func(format string, args ...any) {
+		line := fmt.Sprintf(format, args...)
+		if r := []rune(line); len(r) > cols {
+			line = string(r[:cols])
+		}
+		lines = append(lines, line)
+	}
+	duration := func(d time.Duration) string {
+		if d <= 0 {
+			return "now"
+		}
+		return d.Round(time.Second).String()
+	}
+
+	add("mox top - %s - j/k select, n deliver now, h hold/release, r refresh, q quit", s.Time.Format("15:04:05"))
+	add("")
+
+	add("Connections:")
+	if len(s.Connections) == 0 {
+		add("  (none)")
+	}
+	for _, c := range s.Connections {
+		add("  %-10s %-12s %4d  %s", c.Protocol, c.Listener, c.Count, strings.Join(c.Remotes, " "))
+	}
+	add("")
+
+	add("Queue, %d messages:", s.QueueTotal)
+	if len(s.Queue) == 0 {
+		add("  (none)")
+	}
+	for i, qm := range s.Queue {
+		mark := " "
+		if i == selected {
+			mark = ">"
+		}
+		next := duration(time.Until(qm.NextAttempt))
+		if qm.Hold {
+			next = "hold"
+		}
+		add("%s %5d %-8s %2d %s -> %s  %s", mark, qm.ID, next, qm.Attempts, qm.From, qm.To, qm.LastError)
+	}
+	add("")
+
+	add("Recent events:")
+	if len(s.Events) == 0 {
+		add("  (none)")
+	}
+	for _, e := range s.Events {
+		line := fmt.Sprintf("  %s %-11s", e.Time.Format("15:04:05"), e.Kind)
+		for _, v := range []string{e.Account, e.Recipient, e.Remote, e.Result, e.Detail} {
+			if v != "" {
+				line += " " + v
+			}
+		}
+		add("%s", line)
+	}
+
+	for len(lines) < rows-1 {
+		lines = append(lines, "")
+	}
+	if len(lines) > rows-1 {
+		lines = lines[:rows-1]
+	}
+	lines = append(lines, status)
+	// Clear each line after its text, to remove text of the previous render.
+	return "\x1b[H" + strings.Join(lines, "\x1b[K\r\n") + "\x1b[K"
+}
+
+// topStty runs stty for the terminal on stdin, returning its output.
+func topStty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	buf, err := cmd.Output()
+	return string(buf), err
+}
+
+// topSize returns the number of rows and columns of the terminal, with a fallback
+// of 24x80.
+func topSize() (rows, cols int) {
+	out, err := topStty("size")
+	if err == nil {
+		t := strings.Fields(out)
+		if len(t) == 2 {
+			rows, _ = strconv.Atoi(t[0])
+			cols, _ = strconv.Atoi(t[1])
+		}
+	}
+	if rows <= 0 || cols <= 0 {
+		return 24, 80
+	}
+	return rows, cols
+}
@@ -0,0 +1,109 @@
+// Package totp implements time-based one-time passwords, RFC 6238, as used by
+// authenticator apps for two-factor authentication.
+//
+// Only the parameters commonly supported by authenticator apps are implemented:
+// HMAC-SHA1, 6 digits, 30 second time steps.
+package totp
+
+import (
+	"crypto/hmac"
+	cryptorand "crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Period is the duration of a time step, for which a code is valid.
+const Period = 30 * time.Second
+
+// Digits is the number of digits in a code.
+const Digits = 6
+
+// Skew is the number of time steps before and after the current time step for
+// which codes are accepted, to allow for clock drift and time needed to enter
+// the code.
+const Skew = 1
+
+// NewSecret returns a new random secret, of 20 bytes as recommended by RFC 4226.
+func NewSecret() []byte {
+	buf := make([]byte, 20)
+	if _, err := cryptorand.Read(buf); err != nil {
+		panic(fmt.Sprintf("reading random bytes: %v", err))
+	}
+	return buf
+}
+
+// EncodeSecret returns the base32 encoding of secret without padding, the form
+// authenticator apps expect when entering a secret manually.
+func EncodeSecret(secret []byte) string {
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret)
+}
+
+// Counter returns the time step for t.
+func Counter(t time.Time) int64 {
+	return t.Unix() / int64(Period/time.Second)
+}
+
+// Code returns the code for a time step, as HOTP value (RFC 4226) with
+// counter.
+func Code(secret []byte, counter int64) string {
+	return code(secret, counter, Digits)
+}
+
+func code(secret []byte, counter int64, digits int) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation.
+	offset := sum[len(sum)-1] & 0xf
+	v := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, v%mod)
+}
+
+// Verify checks if code is valid for time t, allowing for Skew time steps
+// before and after t. Codes for time steps at or before lastCounter are not
+// accepted, preventing reuse of a code. If valid, the matching time step is
+// returned, to be passed as lastCounter to future calls.
+func Verify(secret []byte, code string, t time.Time, lastCounter int64) (counter int64, ok bool) {
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != Digits {
+		return 0, false
+	}
+	now := Counter(t)
+	for c := now - Skew; c <= now+Skew; c++ {
+		if c <= lastCounter {
+			continue
+		}
+		if hmac.Equal([]byte(Code(secret, c)), []byte(code)) {
+			return c, true
+		}
+	}
+	return 0, false
+}
+
+// URI returns an otpauth URI as used by authenticator apps, typically
+// presented as QR code. Issuer is typically a domain name, account an email
+// address.
+func URI(issuer, account string, secret []byte) string {
+	u := url.URL{
+		Scheme: "otpauth",
+		Host:   "totp",
+		Path:   "/" + issuer + ":" + account,
+	}
+	qs := url.Values{}
+	qs.Set("secret", EncodeSecret(secret))
+	qs.Set("issuer", issuer)
+	u.RawQuery = qs.Encode()
+	return u.String()
+}
@@ -0,0 +1,65 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCode(t *testing.T) {
+	// Test vectors from RFC 6238, appendix B, for SHA1.
+	secret := []byte("12345678901234567890")
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, tc := range tests {
+		c := code(secret, Counter(time.Unix(tc.unix, 0)), 8)
+		if c != tc.code {
+			t.Fatalf("code for %d: got %s, expected %s", tc.unix, c, tc.code)
+		}
+	}
+
+	// First test vector from RFC 4226, appendix D.
+	if c := Code(secret, 0); c != "755224" {
+		t.Fatalf("hotp code for counter 0: got %s, expected 755224", c)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	secret := NewSecret()
+	now := time.Now()
+	counter := Counter(now)
+
+	xverify := func(code string, lastCounter int64, expCounter int64, expOK bool) {
+		t.Helper()
+		c, ok := Verify(secret, code, now, lastCounter)
+		if ok != expOK || c != expCounter {
+			t.Fatalf("verify %q: got %d %v, expected %d %v", code, c, ok, expCounter, expOK)
+		}
+	}
+
+	xverify(Code(secret, counter), 0, counter, true)
+	xverify(Code(secret, counter-1), 0, counter-1, true)
+	xverify(Code(secret, counter+1), 0, counter+1, true)
+	xverify(Code(secret, counter-2), 0, 0, false)     // Too old.
+	xverify(Code(secret, counter), counter, 0, false) // Reused.
+	xverify(Code(secret, counter+1), counter, counter+1, true)
+	xverify("", 0, 0, false)
+	xverify("12345", 0, 0, false)
+
+	code := Code(secret, counter)
+	xverify(code[:3]+" "+code[3:], 0, counter, true) // Spaces ignored.
+
+	uri := URI("mox.example", "mjl@mox.example", []byte("12345678901234567890"))
+	exp := "otpauth://totp/mox.example:mjl@mox.example?issuer=mox.example&secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	if uri != exp {
+		t.Fatalf("uri: got %s, expected %s", uri, exp)
+	}
+}
@@ -0,0 +1,305 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/moxvar"
+	"github.com/mjl-/mox/stub"
+)
+
+var (
+	// Spans by result: "exported", "dropped" (export queue full) or "error" (export
+	// request failed).
+	MetricSpans stub.CounterVec = stub.CounterVecIgnore{}
+)
+
+const (
+	batchSize     = 512
+	queueSize     = 4 * batchSize
+	batchInterval = 5 * time.Second
+)
+
+// Config for exporting spans.
+type Config struct {
+	Endpoint    string            // URL for OTLP over HTTP, typically ending in /v1/traces.
+	Headers     map[string]string // Additional HTTP request headers, e.g. for authentication.
+	ServiceName string            // For the service.name resource attribute. Default "mox".
+	SampleRatio float64           // Fraction of new traces to record. Zero or more than 1 means all traces.
+}
+
+type exporter struct {
+	log         mlog.Log
+	config      Config
+	sampleRatio float64
+	spans       chan *Span
+	flush       chan chan struct{}
+	done        chan struct{}
+	stopped     chan struct{}
+}
+
+// Configure starts recording spans and exporting them to the configured endpoint.
+// Spans are exported in batches, every 5 seconds. A previous configuration is
+// stopped after exporting its pending spans.
+func Configure(elog *slog.Logger, c Config) {
+	if c.ServiceName == "" {
+		c.ServiceName = "mox"
+	}
+	e := &exporter{
+		log:         mlog.New("tracing", elog),
+		config:      c,
+		sampleRatio: clampRatio(c.SampleRatio),
+		spans:       make(chan *Span, queueSize),
+		flush:       make(chan chan struct{}),
+		done:        make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}
+	go e.run()
+	if prev := exp.Swap(e); prev != nil {
+		prev.stop()
+	}
+}
+
+// Stop exports pending spans and stops recording new spans.
+func Stop() {
+	if e := exp.Swap(nil); e != nil {
+		e.stop()
+	}
+}
+
+// Flush exports all pending spans, e.g. before shutdown.
+func Flush() {
+	if e := exp.Load(); e != nil {
+		c := make(chan struct{})
+		e.flush <- c
+		<-c
+	}
+}
+
+func (e *exporter) stop() {
+	close(e.done)
+	<-e.stopped
+}
+
+func (e *exporter) add(s *Span) {
+	select {
+	case e.spans <- s:
+	default:
+		MetricSpans.IncLabels("dropped")
+	}
+}
+
+func (e *exporter) run() {
+	defer close(e.stopped)
+
+	ticker := time.NewTicker(batchInterval)
+	defer ticker.Stop()
+
+	var batch []*Span
+	send := func() {
+		if len(batch) == 0 {
+			return
+		}
+		err := e.export(batch)
+		result := "exported"
+		if err != nil {
+			result = "error"
+			e.log.Errorx("exporting spans", err, slog.Int("nspans", len(batch)))
+		}
+		for range batch {
+			MetricSpans.IncLabels(result)
+		}
+		batch = nil
+	}
+	// drain moves all queued spans to the batch, sending full batches.
+	drain := func() {
+		for {
+			select {
+			case s := <-e.spans:
+				batch = append(batch, s)
+				if len(batch) >= batchSize {
+					send()
+				}
+			default:
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case s := <-e.spans:
+			batch = append(batch, s)
+			if len(batch) >= batchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case c := <-e.flush:
+			drain()
+			send()
+			close(c)
+		case <-e.done:
+			drain()
+			send()
+			return
+		}
+	}
+}
+
+// OTLP JSON types, see
+// https://github.com/open-telemetry/opentelemetry-proto/blob/main/opentelemetry/proto/trace/v1/trace.proto.
+// IDs are hex-encoded, and 64-bit integers are strings.
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              Kind           `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"` // 0 unset, 1 ok, 2 error.
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+func otlpAttrs(l []Attr) []otlpKeyValue {
+	var r []otlpKeyValue
+	for _, a := range l {
+		var v otlpValue
+		switch x := a.Value.(type) {
+		case string:
+			v.StringValue = &x
+		case int64:
+			s := strconv.FormatInt(x, 10)
+			v.IntValue = &s
+		case bool:
+			v.BoolValue = &x
+		case float64:
+			v.DoubleValue = &x
+		default:
+			s := fmt.Sprint(x)
+			v.StringValue = &s
+		}
+		r = append(r, otlpKeyValue{a.Key, v})
+	}
+	return r
+}
+
+func (e *exporter) export(batch []*Span) error {
+	spans := make([]otlpSpan, len(batch))
+	for i, s := range batch {
+		s.mu.Lock()
+		ospan := otlpSpan{
+			TraceID:           hex.EncodeToString(s.sc.TraceID[:]),
+			SpanID:            hex.EncodeToString(s.sc.SpanID[:]),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        otlpAttrs(s.attrs),
+		}
+		if s.err != "" {
+			ospan.Status = otlpStatus{Code: 2, Message: s.err}
+		}
+		s.mu.Unlock()
+		if s.parentID != [8]byte{} {
+			ospan.ParentSpanID = hex.EncodeToString(s.parentID[:])
+		}
+		spans[i] = ospan
+	}
+	req := otlpRequest{
+		ResourceSpans: []otlpResourceSpans{
+			{
+				Resource: otlpResource{
+					Attributes: otlpAttrs([]Attr{
+						String("service.name", e.config.ServiceName),
+						String("service.version", moxvar.Version),
+					}),
+				},
+				ScopeSpans: []otlpScopeSpans{
+					{
+						Scope: otlpScope{Name: "github.com/mjl-/mox", Version: moxvar.Version},
+						Spans: spans,
+					},
+				},
+			},
+		},
+	}
+	buf, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("marshal spans: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	hreq, err := http.NewRequestWithContext(ctx, "POST", e.config.Endpoint, bytes.NewReader(buf))
+	if err != nil {
+		return fmt.Errorf("new request: %v", err)
+	}
+	hreq.Header.Set("Content-Type", "application/json")
+	hreq.Header.Set("User-Agent", "mox/"+moxvar.Version)
+	for k, v := range e.config.Headers {
+		hreq.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(hreq)
+	if err != nil {
+		return fmt.Errorf("http request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("http response status %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	_, err = io.Copy(io.Discard, resp.Body)
+	e.log.Check(err, "reading response body")
+	return nil
+}
@@ -0,0 +1,239 @@
+// Package tracing records spans of work, e.g. for incoming SMTP transactions,
+// DNS lookups and deliveries from the queue, and exports them to an OpenTelemetry
+// collector with OTLP over HTTP, in its JSON encoding.
+//
+// Spans are only recorded after Configure. Without configuration, Start returns a
+// nil span, and all span methods can be called on a nil span.
+//
+// A trace is propagated between processes, or through the queue, with a W3C
+// traceparent string, see TraceParent and ContextWithParent.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math"
+	mathrand "math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Kind of span, with values as used by OTLP.
+type Kind int
+
+const (
+	KindInternal Kind = 1 // Work within mox, the default.
+	KindServer   Kind = 2 // Handling a request from a remote, e.g. an SMTP transaction.
+	KindClient   Kind = 3 // Request to a remote, e.g. an SMTP delivery or DNS lookup.
+)
+
+// Attr is a key/value attribute of a span. Values are strings, int64, bool or
+// float64, see the constructors.
+type Attr struct {
+	Key   string
+	Value any
+}
+
+func String(key, value string) Attr      { return Attr{key, value} }
+func Int(key string, value int) Attr     { return Attr{key, int64(value)} }
+func Int64(key string, value int64) Attr { return Attr{key, value} }
+func Bool(key string, value bool) Attr   { return Attr{key, value} }
+func Float64(key string, v float64) Attr { return Attr{key, v} }
+
+// spanContext identifies a span within a trace, possibly of a remote parent.
+type spanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// Span is an operation within a trace, with a start and end time. A span is
+// started with Start, and must be ended with End. A nil span is valid, and is
+// returned when tracing is not configured.
+type Span struct {
+	sc       spanContext
+	parentID [8]byte
+	name     string
+	kind     Kind
+	start    time.Time
+
+	mu    sync.Mutex
+	end   time.Time
+	attrs []Attr
+	err   string
+	ended bool
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+// exp is the current exporter, nil when tracing is not configured.
+var exp atomic.Pointer[exporter]
+
+// Enabled returns whether spans are recorded.
+func Enabled() bool {
+	return exp.Load() != nil
+}
+
+// Start starts a new internal span as child of the span in ctx, or as child of a
+// remote parent set with ContextWithParent, or as root span of a new trace.
+//
+// The returned context holds the new span, and must be used for work that is
+// part of the span.
+func Start(ctx context.Context, name string, attrs ...Attr) (context.Context, *Span) {
+	return StartKind(ctx, name, KindInternal, attrs...)
+}
+
+// StartKind is like Start, but with an explicit kind of span.
+func StartKind(ctx context.Context, name string, kind Kind, attrs ...Attr) (context.Context, *Span) {
+	e := exp.Load()
+	if e == nil {
+		return ctx, nil
+	}
+	s := newSpan(ctx, e, name, kind, time.Now(), attrs)
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// Record adds a span that was started at start and ends now, for work that is
+// only known to be of interest after it finished, e.g. from a deferred function.
+// If err is non-nil, the span is marked as failed.
+func Record(ctx context.Context, name string, kind Kind, start time.Time, err error, attrs ...Attr) {
+	e := exp.Load()
+	if e == nil {
+		return
+	}
+	s := newSpan(ctx, e, name, kind, start, attrs)
+	s.SetError(err)
+	s.End()
+}
+
+func newSpan(ctx context.Context, e *exporter, name string, kind Kind, start time.Time, attrs []Attr) *Span {
+	s := &Span{name: name, kind: kind, start: start, attrs: attrs}
+	if parent := fromContext(ctx); parent != nil {
+		s.sc.TraceID = parent.sc.TraceID
+		s.sc.Sampled = parent.sc.Sampled
+		s.parentID = parent.sc.SpanID
+	} else if remote, ok := ctx.Value(remoteKey{}).(spanContext); ok {
+		s.sc.TraceID = remote.TraceID
+		s.sc.Sampled = remote.Sampled
+		s.parentID = remote.SpanID
+	} else {
+		randomID(s.sc.TraceID[:])
+		s.sc.Sampled = e.sampleRatio >= 1 || mathrand.Float64() < e.sampleRatio
+	}
+	randomID(s.sc.SpanID[:])
+	return s
+}
+
+func randomID(buf []byte) {
+	if _, err := rand.Read(buf); err != nil {
+		panic(fmt.Sprintf("reading random id: %v", err))
+	}
+}
+
+func fromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// SetAttrs adds attributes to the span.
+func (s *Span) SetAttrs(attrs ...Attr) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attrs = append(s.attrs, attrs...)
+}
+
+// SetError marks the span as failed, if err is not nil.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err.Error()
+}
+
+// End ends the span and queues it for export if the trace is sampled. Calling End
+// more than once has no effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+
+	if !s.sc.Sampled {
+		return
+	}
+	if e := exp.Load(); e != nil {
+		e.add(s)
+	}
+}
+
+// TraceParent returns the W3C traceparent for the span in ctx, or an empty string
+// if there is no span.
+func TraceParent(ctx context.Context) string {
+	s := fromContext(ctx)
+	if s == nil {
+		return ""
+	}
+	var flags byte
+	if s.sc.Sampled {
+		flags = 1
+	}
+	return fmt.Sprintf("00-%x-%x-%02x", s.sc.TraceID, s.sc.SpanID, flags)
+}
+
+// ContextWithParent returns a context with a remote parent span from a W3C
+// traceparent, as returned by TraceParent. New spans started with the returned
+// context are part of the trace of traceparent. If traceparent is empty or
+// invalid, ctx is returned.
+func ContextWithParent(ctx context.Context, traceparent string) context.Context {
+	sc, ok := parseTraceParent(traceparent)
+	if !ok {
+		return ctx
+	}
+	// Clear any local span, it would otherwise take precedence as parent.
+	ctx = context.WithValue(ctx, spanKey{}, (*Span)(nil))
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+func parseTraceParent(s string) (sc spanContext, ok bool) {
+	t := strings.Split(s, "-")
+	if len(t) != 4 || t[0] != "00" || len(t[1]) != 32 || len(t[2]) != 16 || len(t[3]) != 2 {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(t[1])); err != nil || sc.TraceID == [16]byte{} {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(t[2])); err != nil || sc.SpanID == [8]byte{} {
+		return sc, false
+	}
+	var flags [1]byte
+	if _, err := hex.Decode(flags[:], []byte(t[3])); err != nil {
+		return sc, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, true
+}
+
+// clampRatio returns a sample ratio between 0 and 1, with 0 meaning all traces.
+func clampRatio(v float64) float64 {
+	if v <= 0 || math.IsNaN(v) {
+		return 1
+	}
+	return math.Min(v, 1)
+}
@@ -0,0 +1,117 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func tcheck(t *testing.T, err error, msg string) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %s", msg, err)
+	}
+}
+
+func tcompare(t *testing.T, got, exp any) {
+	t.Helper()
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("got:\n%#v\nexpected:\n%#v", got, exp)
+	}
+}
+
+func TestTracing(t *testing.T) {
+	// Without configuration, nothing is recorded.
+	ctx, span := Start(context.Background(), "test")
+	tcompare(t, span == nil, true)
+	span.SetAttrs(String("k", "v"))
+	span.SetError(errors.New("boom"))
+	span.End()
+	tcompare(t, TraceParent(ctx), "")
+
+	var mu sync.Mutex
+	var spans []otlpSpan
+	var headers http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req otlpRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		tcheck(t, err, "decode request")
+		mu.Lock()
+		defer mu.Unlock()
+		headers = r.Header
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+	}))
+	defer srv.Close()
+
+	Configure(nil, Config{Endpoint: srv.URL, Headers: map[string]string{"Authorization": "Bearer test"}})
+	defer Stop()
+
+	ctx, root := StartKind(context.Background(), "smtp.receive", KindServer, String("remote.ip", "127.0.0.1"))
+	cctx, child := Start(ctx, "junk.analyze")
+	child.SetAttrs(Float64("junk.probability", 0.5))
+	Record(cctx, "dns.lookup", KindClient, time.Now(), errors.New("nxdomain"), String("dns.type", "txt"))
+	child.End()
+	root.End()
+	root.End() // No effect.
+
+	// Continue the trace, as through the queue.
+	tp := TraceParent(ctx)
+	qctx := ContextWithParent(context.Background(), tp)
+	_, delivery := StartKind(qctx, "queue.deliver", KindClient)
+	delivery.End()
+
+	// Invalid traceparent starts a new trace.
+	_, other := Start(ContextWithParent(context.Background(), "bogus"), "other")
+	other.End()
+
+	Flush()
+
+	mu.Lock()
+	defer mu.Unlock()
+	tcompare(t, headers.Get("Authorization"), "Bearer test")
+	tcompare(t, len(spans), 5)
+	byName := map[string]otlpSpan{}
+	for _, s := range spans {
+		byName[s.Name] = s
+	}
+	rs := byName["smtp.receive"]
+	tcompare(t, rs.Kind, KindServer)
+	tcompare(t, rs.ParentSpanID, "")
+	tcompare(t, *rs.Attributes[0].Value.StringValue, "127.0.0.1")
+	tcompare(t, byName["junk.analyze"].ParentSpanID, rs.SpanID)
+	tcompare(t, byName["junk.analyze"].TraceID, rs.TraceID)
+	tcompare(t, *byName["junk.analyze"].Attributes[0].Value.DoubleValue, 0.5)
+	tcompare(t, byName["dns.lookup"].ParentSpanID, byName["junk.analyze"].SpanID)
+	tcompare(t, byName["dns.lookup"].Status, otlpStatus{Code: 2, Message: "nxdomain"})
+	tcompare(t, byName["queue.deliver"].TraceID, rs.TraceID)
+	tcompare(t, byName["queue.deliver"].ParentSpanID, rs.SpanID)
+	tcompare(t, byName["other"].TraceID != rs.TraceID, true)
+	tcompare(t, byName["other"].ParentSpanID, "")
+}
+
+func TestTraceParent(t *testing.T) {
+	sc, ok := parseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	tcompare(t, ok, true)
+	tcompare(t, sc.Sampled, true)
+
+	for _, s := range []string{
+		"",
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-x1",
+	} {
+		_, ok := parseTraceParent(s)
+		tcompare(t, ok, false)
+	}
+}
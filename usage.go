@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/mjl-/mox/accounting"
+	"github.com/mjl-/mox/admindb"
+)
+
+func cmdUsage(c *cmd) {
+	c.params = "[-start yyyy-mm] [-end yyyy-mm] [-account account] [-domain domain] [-group account|domain] [-format text|csv|json]"
+	c.help = `List monthly usage of accounts and domains, for reporting and billing.
+
+Usage is recorded per calendar month (UTC), per account and domain:
+
+- Incoming messages delivered to the account, per recipient, and their total
+  size. The domain is that of the recipient address.
+- Outgoing messages delivered to remote mail servers, per recipient, and their
+  total size, i.e. outgoing bandwidth. The domain is that of the sender address.
+- The highest total size of stored messages of the account during the month,
+  sampled hourly. Recorded for the default domain of the account.
+
+With -group, usage is summed per month and account, or per month and domain.
+The same usage is available through the admin API, with method UsageList, and
+in the admin web interface.
+`
+	var f admindb.UsageFilter
+	var group string
+	format := "text"
+	c.flag.StringVar(&f.Start, "start", "", "first month, e.g. 2026-01")
+	c.flag.StringVar(&f.End, "end", "", "last month, inclusive")
+	c.flag.StringVar(&f.Account, "account", "", "only usage of this account")
+	c.flag.StringVar(&f.Domain, "domain", "", "only usage for this domain")
+	c.flag.StringVar(&group, "group", "", "sum per month and account or per month and domain, instead of per month, account and domain")
+	c.flag.StringVar(&format, "format", format, "output format: text, csv or json")
+	args := c.Parse()
+	if len(args) != 0 {
+		c.Usage()
+	}
+	if format != "text" && format != "csv" && format != "json" {
+		log.Fatalf("unknown format %q, must be text, csv or json", format)
+	}
+
+	if rc := xremote(); rc != nil {
+		remotecmdUsage(rc, f, group, format)
+		return
+	}
+	mustLoadConfig()
+	ctlcmdUsage(xctl(), f, group, format)
+}
+
+func ctlcmdUsage(ctl *ctl, f admindb.UsageFilter, group, format string) {
+	ctl.xwrite("usage")
+	ctl.xwrite(f.Start)
+	ctl.xwrite(f.End)
+	ctl.xwrite(f.Account)
+	ctl.xwrite(f.Domain)
+	ctl.xwrite(group)
+	ctl.xreadok()
+	var b bytes.Buffer
+	ctl.xstreamto(&b)
+	var l []admindb.Usage
+	err := json.Unmarshal(b.Bytes(), &l)
+	xcheckf(err, "parsing usage")
+	err = usageWrite(os.Stdout, l, format)
+	xcheckf(err, "writing usage")
+}
+
+// usageWrite writes usage records in format text, csv or json.
+func usageWrite(w io.Writer, l []admindb.Usage, format string) error {
+	switch format {
+	case "csv":
+		return accounting.WriteCSV(w, l)
+	case "json":
+		if l == nil {
+			l = []admindb.Usage{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		return enc.Encode(l)
+	}
+
+	if len(l) == 0 {
+		_, err := fmt.Fprintln(w, "(none)")
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "%-7s  %-20s  %-25s  %10s  %14s  %10s  %14s  %14s\n", "month", "account", "domain", "received", "received bytes", "sent", "sent bytes", "stored bytes"); err != nil {
+		return err
+	}
+	for _, u := range l {
+		if _, err := fmt.Fprintf(w, "%-7s  %-20s  %-25s  %10d  %14d  %10d  %14d  %14d\n", u.Month, u.Account, u.Domain, u.MessagesReceived, u.BytesReceived, u.MessagesSent, u.BytesSent, u.StoredBytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
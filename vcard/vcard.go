@@ -0,0 +1,189 @@
+// Package vcard parses and writes vCards, RFC 6350 (version 4.0) and RFC 2426
+// (version 3.0), for the address book of an account.
+//
+// A card is kept as a list of properties, so properties that mox does not
+// interpret, e.g. photos or birthdays set by a phone, are preserved when a card
+// is modified and written again.
+package vcard
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mjl-/mox/internal/contentline"
+)
+
+var ErrSyntax = errors.New("vcard syntax error")
+
+// Param is a property parameter, e.g. TYPE=work,voice.
+type Param = contentline.Param
+
+// Prop is a property (content line) of a card.
+type Prop struct {
+	Group  string // Optional, before the name, separated by a dot.
+	Name   string // Upper case, e.g. FN, EMAIL, TEL.
+	Params []Param
+	Value  string // Raw value, still escaped. See Text for unescaping.
+}
+
+// Text returns the value unescaped as text, with \n, \, and \; resolved.
+func (p Prop) Text() string {
+	return contentline.Unescape(p.Value)
+}
+
+// Param returns the first value of the named parameter, or the empty string.
+func (p Prop) Param(name string) string {
+	return contentline.ParamValue(p.Params, name)
+}
+
+// Card is a single vCard, between BEGIN:VCARD and END:VCARD. The BEGIN and END
+// properties are not included in Props.
+type Card struct {
+	Props []Prop
+}
+
+// Get returns the first property with name, and whether it was found.
+func (c Card) Get(name string) (Prop, bool) {
+	for _, p := range c.Props {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Prop{}, false
+}
+
+// Text returns the unescaped value of the first property with name, or the
+// empty string if absent.
+func (c Card) Text(name string) string {
+	p, _ := c.Get(name)
+	return p.Text()
+}
+
+// Texts returns the unescaped values of all properties with name.
+func (c Card) Texts(name string) []string {
+	var l []string
+	for _, p := range c.Props {
+		if p.Name == name {
+			l = append(l, p.Text())
+		}
+	}
+	return l
+}
+
+// SetTexts replaces all properties with name by new properties, one for each
+// value, escaped as text. Properties with an empty value are not added. The new
+// properties are added at the position of the first removed property, or at the
+// end. If values are unchanged, the existing properties, including their
+// parameters, are kept.
+func (c *Card) SetTexts(name string, values ...string) {
+	var nvalues []string
+	for _, v := range values {
+		if v != "" {
+			nvalues = append(nvalues, v)
+		}
+	}
+	if equal(c.Texts(name), nvalues) {
+		return
+	}
+
+	var props []Prop
+	pos := -1
+	for _, p := range c.Props {
+		if p.Name == name {
+			if pos < 0 {
+				pos = len(props)
+			}
+			continue
+		}
+		props = append(props, p)
+	}
+	if pos < 0 {
+		pos = len(props)
+	}
+	var nprops []Prop
+	for _, v := range nvalues {
+		nprops = append(nprops, Prop{Name: name, Value: Escape(v)})
+	}
+	c.Props = append(props[:pos], append(nprops, props[pos:]...)...)
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Escape escapes a text value for use in a property.
+func Escape(s string) string {
+	return contentline.Escape(s)
+}
+
+// Parse parses one or more cards from r.
+func Parse(r io.Reader) ([]Card, error) {
+	lines, err := contentline.Unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var cards []Card
+	var cur *Card
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		p, err := parseProp(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		switch {
+		case p.Name == "BEGIN" && strings.EqualFold(p.Value, "VCARD"):
+			if cur != nil {
+				return nil, fmt.Errorf("%w: line %d: nested vcard", ErrSyntax, i+1)
+			}
+			cur = &Card{}
+		case p.Name == "END" && strings.EqualFold(p.Value, "VCARD"):
+			if cur == nil {
+				return nil, fmt.Errorf("%w: line %d: end without begin", ErrSyntax, i+1)
+			}
+			cards = append(cards, *cur)
+			cur = nil
+		case cur == nil:
+			return nil, fmt.Errorf("%w: line %d: property outside vcard", ErrSyntax, i+1)
+		default:
+			cur.Props = append(cur.Props, p)
+		}
+	}
+	if cur != nil {
+		return nil, fmt.Errorf("%w: missing end of vcard", ErrSyntax)
+	}
+	return cards, nil
+}
+
+func parseProp(line string) (Prop, error) {
+	l, err := contentline.Parse(line, true)
+	if err != nil {
+		return Prop{}, fmt.Errorf("%w: %v", ErrSyntax, err)
+	}
+	return Prop(l), nil
+}
+
+// Marshal returns the card in vCard format, with CRLF line endings and lines
+// folded at 75 octets.
+func (c Card) Marshal() []byte {
+	var b bytes.Buffer
+	contentline.WriteLine(&b, "BEGIN:VCARD")
+	for _, p := range c.Props {
+		contentline.Line(p).Write(&b)
+	}
+	contentline.WriteLine(&b, "END:VCARD")
+	return b.Bytes()
+}
@@ -0,0 +1,99 @@
+package vcard
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func tcompare(t *testing.T, got, exp any) {
+	t.Helper()
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("got:\n%#v\nexpected:\n%#v", got, exp)
+	}
+}
+
+func TestParse(t *testing.T) {
+	const data = "BEGIN:VCARD\r\n" +
+		"VERSION:3.0\r\n" +
+		"FN:Mox Test\\, Jr.\r\n" +
+		"item1.EMAIL;TYPE=INTERNET,WORK:mox@mox.example\r\n" +
+		"EMAIL;type=\"home;x\":other@mox.example\r\n" +
+		"TEL;WORK:+1 555\r\n" +
+		"NOTE:line one\\nline two that is long enough to be folded over multiple lines\r\n" +
+		"  in the input\r\n" +
+		"END:VCARD\r\n" +
+		"BEGIN:VCARD\nFN:Second\nEND:VCARD\n"
+
+	cards, err := Parse(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	tcompare(t, len(cards), 2)
+	c := cards[0]
+	tcompare(t, c.Text("FN"), "Mox Test, Jr.")
+	tcompare(t, c.Texts("EMAIL"), []string{"mox@mox.example", "other@mox.example"})
+	tcompare(t, c.Props[2].Group, "item1")
+	tcompare(t, c.Props[2].Params, []Param{{Name: "TYPE", Values: []string{"INTERNET", "WORK"}}})
+	tcompare(t, c.Props[3].Param("type"), "home;x")
+	tcompare(t, c.Props[4].Params, []Param{{Name: "TYPE", Values: []string{"WORK"}}})
+	tcompare(t, c.Text("NOTE"), "line one\nline two that is long enough to be folded over multiple lines in the input")
+	tcompare(t, cards[1].Text("FN"), "Second")
+
+	// Round trip.
+	buf := c.Marshal()
+	for _, line := range strings.Split(string(buf), "\r\n") {
+		if len(line) > 75 {
+			t.Fatalf("line not folded: %q", line)
+		}
+	}
+	ncards, err := Parse(strings.NewReader(string(buf)))
+	if err != nil {
+		t.Fatalf("parse marshaled card: %v", err)
+	}
+	tcompare(t, ncards, []Card{c})
+
+	bad := []string{
+		"FN:outside\r\n",
+		"BEGIN:VCARD\r\nFN:x\r\n",
+		"BEGIN:VCARD\r\nBEGIN:VCARD\r\n",
+		"END:VCARD\r\n",
+		"BEGIN:VCARD\r\nnovalue\r\nEND:VCARD\r\n",
+		"BEGIN:VCARD\r\nEMAIL;TYPE=\"x:a@b\r\nEND:VCARD\r\n",
+	}
+	for _, s := range bad {
+		_, err := Parse(strings.NewReader(s))
+		if !errors.Is(err, ErrSyntax) {
+			t.Fatalf("parsing %q: got err %v, expected ErrSyntax", s, err)
+		}
+	}
+}
+
+func TestSetTexts(t *testing.T) {
+	c := Card{Props: []Prop{
+		{Name: "VERSION", Value: "3.0"},
+		{Name: "EMAIL", Params: []Param{{Name: "TYPE", Values: []string{"WORK"}}}, Value: "a@mox.example"},
+		{Name: "FN", Value: "A"},
+		{Name: "EMAIL", Value: "b@mox.example"},
+	}}
+
+	// Unchanged, params are kept.
+	c.SetTexts("EMAIL", "a@mox.example", "b@mox.example")
+	tcompare(t, c.Props[1].Params, []Param{{Name: "TYPE", Values: []string{"WORK"}}})
+
+	c.SetTexts("EMAIL", "c@mox.example", "")
+	tcompare(t, c.Props, []Prop{
+		{Name: "VERSION", Value: "3.0"},
+		{Name: "EMAIL", Value: "c@mox.example"},
+		{Name: "FN", Value: "A"},
+	})
+
+	c.SetTexts("NOTE", "a; b, c\nd")
+	tcompare(t, c.Props[3], Prop{Name: "NOTE", Value: `a\; b\, c\nd`})
+	tcompare(t, c.Text("NOTE"), "a; b, c\nd")
+
+	c.SetTexts("FN")
+	_, ok := c.Get("FN")
+	tcompare(t, ok, false)
+}
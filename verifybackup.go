@@ -0,0 +1,318 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/message"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/store"
+)
+
+func cmdVerifybackup(c *cmd) {
+	c.params = "[-deep] backup"
+	c.help = `Verify the files in a backup against its manifest.
+
+The backup can be a backup directory, a tar file as written by "mox backup -",
+or "-" to read a tar file from stdin, e.g. "aws s3 cp s3://bucket/mox.tar - |
+mox verifybackup -".
+
+The size of each file in the manifest is checked, and the SHA-256 hash for
+files other than messages. For databases, the hash of each chunk is checked,
+including the chunks in database delta files of incremental streamed backups.
+Files in a backup directory that are not in the manifest are reported.
+
+With -deep, only for backup directories, the message files are checked against
+the queue and account databases: each message file must exist, its size must
+match the size in the database, and the message must parse to the same
+structure as stored in the database. Message files that are encrypted at rest
+are only checked for their presence.
+
+For checking the databases themselves, and consistency of the data within, see
+"mox verifydata".
+`
+	var deep bool
+	c.flag.BoolVar(&deep, "deep", false, "check message files against the queue and account databases")
+	args := c.Parse()
+	if len(args) != 1 {
+		c.Usage()
+	}
+
+	var fail bool
+	checkf := func(err error, path, format string, args ...any) {
+		if err == nil {
+			return
+		}
+		fail = true
+		log.Printf("error: %s: %s: %v", path, fmt.Sprintf(format, args...), err)
+	}
+
+	p := args[0]
+	if fi, err := os.Stat(p); err == nil && fi.IsDir() {
+		verifyBackupDir(p, checkf)
+		if deep {
+			verifyBackupMessages(c.log, p, checkf)
+		}
+	} else {
+		if deep {
+			log.Fatalf("-deep requires a backup directory")
+		}
+		var r io.Reader = os.Stdin
+		if p != "-" {
+			f, err := os.Open(p)
+			xcheckf(err, "open backup")
+			defer f.Close()
+			r = f
+		}
+		verifyBackupTar(r, checkf)
+	}
+
+	if fail {
+		log.Fatalf("errors were found")
+	}
+	fmt.Printf("%s: OK\n", p)
+}
+
+// backupFileSums holds the size and hashes of a file in a backup.
+type backupFileSums struct {
+	size   int64
+	sha256 string
+	chunks []string
+}
+
+func backupReadSums(r io.Reader) (backupFileSums, error) {
+	h := sha256.New()
+	ch := &chunkHasher{}
+	n, err := io.Copy(io.MultiWriter(h, ch), r)
+	return backupFileSums{n, hex.EncodeToString(h.Sum(nil)), ch.sums()}, err
+}
+
+// checkBackupEntry compares the sums of a file with its manifest entry. For
+// delta files, sums are for the chunks present.
+func checkBackupEntry(e backupEntry, sums backupFileSums) error {
+	if e.Delta {
+		if len(sums.chunks) != len(e.DeltaChunks) {
+			return fmt.Errorf("delta file has %d chunks, manifest lists %d", len(sums.chunks), len(e.DeltaChunks))
+		}
+		for i, c := range e.DeltaChunks {
+			if c < 0 || c >= len(e.Chunks) {
+				return fmt.Errorf("delta chunk %d out of range", c)
+			}
+			if sums.chunks[i] != e.Chunks[c] {
+				return fmt.Errorf("hash mismatch for chunk %d", c)
+			}
+		}
+		return nil
+	}
+	if sums.size != e.Size {
+		return fmt.Errorf("size is %d, manifest has %d", sums.size, e.Size)
+	}
+	if e.SHA256 != "" && sums.sha256 != e.SHA256 {
+		return fmt.Errorf("sha256 mismatch")
+	}
+	if e.Chunks != nil {
+		if len(sums.chunks) != len(e.Chunks) {
+			return fmt.Errorf("file has %d chunks, manifest has %d", len(sums.chunks), len(e.Chunks))
+		}
+		for i, c := range e.Chunks {
+			if sums.chunks[i] != c {
+				return fmt.Errorf("hash mismatch for chunk %d", i)
+			}
+		}
+	}
+	return nil
+}
+
+func verifyBackupDir(dir string, checkf func(err error, path, format string, args ...any)) {
+	manifest, err := readBackupManifest(dir)
+	xcheckf(err, "reading manifest")
+
+	known := map[string]struct{}{backupManifestName: {}}
+	for _, e := range manifest.Files {
+		known[e.Path] = struct{}{}
+		p := filepath.Join(dir, filepath.FromSlash(e.Path))
+		if e.Omitted || e.Delta {
+			checkf(errors.New("file is part of a streamed incremental backup"), p, "checking file")
+			continue
+		}
+		if e.SHA256 == "" && e.Chunks == nil {
+			// Message file, only check the size.
+			fi, err := os.Stat(p)
+			checkf(err, p, "stat file")
+			if err == nil && fi.Size() != e.Size {
+				checkf(fmt.Errorf("size is %d, manifest has %d", fi.Size(), e.Size), p, "checking file")
+			}
+			continue
+		}
+		f, err := os.Open(p)
+		checkf(err, p, "open file")
+		if err != nil {
+			continue
+		}
+		sums, err := backupReadSums(f)
+		f.Close()
+		checkf(err, p, "reading file")
+		if err == nil {
+			checkf(checkBackupEntry(e, sums), p, "checking file")
+		}
+	}
+
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		checkf(err, p, "walk")
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if _, ok := known[filepath.ToSlash(p[len(dir)+1:])]; !ok {
+			log.Printf("warning: %s: file not in manifest", p)
+		}
+		return nil
+	})
+	checkf(err, dir, "walking backup directory")
+}
+
+func verifyBackupTar(r io.Reader, checkf func(err error, path, format string, args ...any)) {
+	// The manifest is the last file, we gather the sums of all files first.
+	files := map[string]backupFileSums{}
+	var manifest *backupManifest
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		xcheckf(err, "reading tar file")
+		if manifest != nil {
+			checkf(errors.New("file after manifest"), h.Name, "reading tar file")
+		}
+		if h.Name == backupManifestName {
+			var m backupManifest
+			err := json.NewDecoder(tr).Decode(&m)
+			xcheckf(err, "parsing manifest")
+			if m.Version != 1 {
+				log.Fatalf("unsupported manifest version %d", m.Version)
+			}
+			manifest = &m
+			continue
+		}
+		sums, err := backupReadSums(tr)
+		xcheckf(err, "reading tar file")
+		files[h.Name] = sums
+	}
+	if manifest == nil {
+		log.Fatalf("no manifest in backup, backup is incomplete")
+	}
+
+	var omitted int
+	for _, e := range manifest.Files {
+		if e.Omitted {
+			omitted++
+			continue
+		}
+		name := e.Path
+		if e.Delta {
+			name += ".delta"
+		}
+		sums, ok := files[name]
+		if !ok {
+			checkf(errors.New("missing in backup"), name, "checking file")
+			continue
+		}
+		delete(files, name)
+		checkf(checkBackupEntry(e, sums), name, "checking file")
+	}
+	for name := range files {
+		log.Printf("warning: %s: file not in manifest", name)
+	}
+	if !manifest.Previous.IsZero() {
+		fmt.Printf("incremental backup relative to backup of %s, %d unchanged message files not included\n", manifest.Previous.Format("2006-01-02 15:04:05"), omitted)
+	}
+}
+
+// verifyBackupMessages checks the message files in a backup directory against
+// the queue and account databases.
+func verifyBackupMessages(log mlog.Log, dir string, checkf func(err error, path, format string, args ...any)) {
+	ctxbg := context.Background()
+
+	var encrypted int
+	checkMessage := func(dbpath, path string, prefix []byte, size int64, m *store.Message) {
+		filesize, err := store.MessageFileSize(path, nil, m != nil && m.FileEncrypted, m != nil && m.FileCompressed)
+		if errors.Is(err, store.ErrNoMessageKey) {
+			encrypted++
+			return
+		}
+		checkf(err, path, "checking message file")
+		if err != nil {
+			return
+		}
+		if int64(len(prefix))+filesize != size {
+			checkf(fmt.Errorf("message size is %d, database has %d", int64(len(prefix))+filesize, size), path, "checking message size")
+			return
+		}
+		if m == nil {
+			return
+		}
+		mr := store.PathMsgReader(prefix, path, nil, false, m.FileCompressed, size)
+		defer mr.Close()
+		p, err := message.EnsurePart(log.Logger, false, mr, size)
+		checkf(err, path, "parsing message")
+		if err != nil {
+			return
+		}
+		xp, err := m.LoadPart(mr)
+		checkf(err, dbpath, "loading parsed message %d from database", m.ID)
+		if err == nil {
+			checkf(store.ComparePartStructure(p, xp), path, "comparing message structure with database")
+		}
+	}
+
+	checkDB := func(dbpath string, types []any, fn func(db *bstore.DB) error) {
+		db, err := bstore.Open(ctxbg, dbpath, &bstore.Options{MustExist: true}, types...)
+		checkf(err, dbpath, "opening database")
+		if err != nil {
+			return
+		}
+		defer db.Close()
+		checkf(fn(db), dbpath, "checking message files")
+	}
+
+	dbpath := filepath.Join(dir, "queue", "index.db")
+	checkDB(dbpath, queue.DBTypes, func(db *bstore.DB) error {
+		return bstore.QueryDB[queue.Msg](ctxbg, db).ForEach(func(qm queue.Msg) error {
+			checkMessage(dbpath, filepath.Join(dir, "queue", store.MessagePath(qm.ID)), qm.MsgPrefix, qm.Size, nil)
+			return nil
+		})
+	})
+
+	accdirs, err := os.ReadDir(filepath.Join(dir, "accounts"))
+	checkf(err, dir, "listing accounts")
+	for _, d := range accdirs {
+		if !d.IsDir() {
+			continue
+		}
+		accdir := filepath.Join(dir, "accounts", d.Name())
+		dbpath := filepath.Join(accdir, "index.db")
+		checkDB(dbpath, store.DBTypes, func(db *bstore.DB) error {
+			return bstore.QueryDB[store.Message](ctxbg, db).FilterEqual("Expunged", false).ForEach(func(m store.Message) error {
+				checkMessage(dbpath, filepath.Join(accdir, "msg", store.MessagePath(m.ID)), m.Sealed.MsgPrefix, m.Size, &m)
+				return nil
+			})
+		})
+	}
+
+	if encrypted > 0 {
+		fmt.Printf("%d message files are encrypted, only their presence was checked\n", encrypted)
+	}
+}
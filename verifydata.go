@@ -16,11 +16,13 @@ import (
 
 	"github.com/mjl-/bstore"
 
+	"github.com/mjl-/mox/admindb"
 	"github.com/mjl-/mox/dmarcdb"
 	"github.com/mjl-/mox/junk"
 	"github.com/mjl-/mox/moxvar"
 	"github.com/mjl-/mox/mtastsdb"
 	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/replication"
 	"github.com/mjl-/mox/store"
 	"github.com/mjl-/mox/tlsrptdb"
 )
@@ -147,12 +149,15 @@ possibly making them potentially no longer readable by the previous version.
 		checkf(err, path, "checking database file")
 	}
 
-	checkFile := func(dbpath, path string, prefixSize int, size int64) {
-		st, err := os.Stat(path)
+	checkFile := func(dbpath, path string, prefixSize int, size int64, encrypted, compressed bool) {
+		filesize, err := store.MessageFileSize(path, nil, encrypted, compressed)
+		if errors.Is(err, store.ErrNoMessageKey) {
+			// Encrypted message file, the size cannot be checked without the key.
+			return
+		}
 		checkf(err, path, "checking if file exists")
-		if !skipSizeCheck && err == nil && int64(prefixSize)+st.Size() != size {
-			filesize := st.Size()
-			checkf(fmt.Errorf("%s: message size is %d, should be %d (length of MsgPrefix %d + file size %d), see \"mox fixmsgsize\"", path, size, int64(prefixSize)+st.Size(), prefixSize, filesize), dbpath, "checking message size")
+		if !skipSizeCheck && err == nil && int64(prefixSize)+filesize != size {
+			checkf(fmt.Errorf("%s: message size is %d, should be %d (length of MsgPrefix %d + file size %d), see \"mox fixmsgsize\"", path, size, int64(prefixSize)+filesize, prefixSize, filesize), dbpath, "checking message size")
 		}
 	}
 
@@ -169,7 +174,7 @@ possibly making them potentially no longer readable by the previous version.
 				mp := store.MessagePath(m.ID)
 				seen[mp] = struct{}{}
 				p := filepath.Join(dataDir, "queue", mp)
-				checkFile(dbpath, p, len(m.MsgPrefix), m.Size)
+				checkFile(dbpath, p, len(m.MsgPrefix), m.Size, false, false)
 				return nil
 			})
 			checkf(err, dbpath, "reading messages in queue database to check files")
@@ -287,7 +292,7 @@ possibly making them potentially no longer readable by the previous version.
 				mp := store.MessagePath(m.ID)
 				seen[mp] = struct{}{}
 				p := filepath.Join(accdir, "msg", mp)
-				checkFile(dbpath, p, len(m.MsgPrefix), m.Size)
+				checkFile(dbpath, p, len(m.Sealed.MsgPrefix), m.Size, m.FileEncrypted, m.FileCompressed)
 
 				if up.Threads != 2 {
 					return nil
@@ -419,9 +424,9 @@ possibly making them potentially no longer readable by the previous version.
 				p = p[len(dataDir)+1:]
 			}
 			switch p {
-			case "dmarcrpt.db", "dmarceval.db", "mtasts.db", "tlsrpt.db", "tlsrptresult.db", "receivedid.key", "lastknownversion":
+			case "dmarcrpt.db", "dmarceval.db", "mtasts.db", "tlsrpt.db", "tlsrptresult.db", "admin.db", "receivedid.key", "lastknownversion", "webpush-vapid.key", replication.StateFile, backupManifestName, store.ScrubStateFile, store.SharedJunkFilterFiles[0], store.SharedJunkFilterFiles[1]:
 				return nil
-			case "acme", "queue", "accounts", "tmp", "moved":
+			case "acme", "queue", "accounts", "tmp", "moved", "blobs", "quarantine", "moderation":
 				return fs.SkipDir
 			case "moxversion":
 				buf, err := os.ReadFile(dpath)
@@ -442,6 +447,7 @@ possibly making them potentially no longer readable by the previous version.
 	checkDB(true, filepath.Join(dataDir, "mtasts.db"), mtastsdb.DBTypes)
 	checkDB(true, filepath.Join(dataDir, "tlsrpt.db"), tlsrptdb.ReportDBTypes)
 	checkDB(false, filepath.Join(dataDir, "tlsrptresult.db"), tlsrptdb.ResultDBTypes) // After v0.0.7.
+	checkDB(false, filepath.Join(dataDir, "admin.db"), admindb.DBTypes)
 	checkQueue()
 	checkAccounts()
 	checkOther()
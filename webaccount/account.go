@@ -12,10 +12,12 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
@@ -25,18 +27,27 @@ import (
 	"github.com/mjl-/sherpa"
 	"github.com/mjl-/sherpadoc"
 	"github.com/mjl-/sherpaprom"
+	"rsc.io/qr"
 
+	"github.com/mjl-/mox/accountdel"
+	"github.com/mjl-/mox/admindb"
 	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/i18n"
+	"github.com/mjl-/mox/imapmigrate"
 	"github.com/mjl-/mox/mlog"
-	"github.com/mjl-/mox/mox-"
+	mox "github.com/mjl-/mox/mox-"
 	"github.com/mjl-/mox/moxvar"
+	"github.com/mjl-/mox/quarantine"
 	"github.com/mjl-/mox/queue"
 	"github.com/mjl-/mox/smtp"
 	"github.com/mjl-/mox/store"
+	"github.com/mjl-/mox/totp"
 	"github.com/mjl-/mox/webapi"
 	"github.com/mjl-/mox/webauth"
 	"github.com/mjl-/mox/webhook"
 	"github.com/mjl-/mox/webops"
+	"github.com/mjl-/mox/webpush"
 )
 
 var pkglog = mlog.New("webaccount", nil)
@@ -193,8 +204,18 @@ func handle(apiHandler http.Handler, isForwarded bool, w http.ResponseWriter, r
 		}
 	}
 
+	// Without authentication. The token from the link sent to the alias owner is
+	// unguessable.
+	if r.URL.Path == "/moderate" {
+		handleModerate(ctx, log, w, r)
+		return
+	}
+
 	// HTML/JS can be retrieved without authentication.
-	if r.URL.Path == "/" {
+	if r.URL.Path == "/i18n.json" {
+		i18n.ServeJSON(log, w, r)
+		return
+	} else if r.URL.Path == "/" {
 		switch r.Method {
 		case "GET", "HEAD":
 			webaccountFile.Serve(ctx, log, w, r)
@@ -214,9 +235,9 @@ func handle(apiHandler http.Handler, isForwarded bool, w http.ResponseWriter, r
 	var loginAddress, accName string
 	var sessionToken store.SessionToken
 	// All other URLs, except the login endpoint require some authentication.
-	if r.URL.Path != "/api/LoginPrep" && r.URL.Path != "/api/Login" {
+	if r.URL.Path != "/api/LoginPrep" && r.URL.Path != "/api/Login" && r.URL.Path != "/api/PasskeyLoginPrep" && r.URL.Path != "/api/PasskeyLogin" && r.URL.Path != "/api/OIDCEnabled" && r.URL.Path != "/api/OIDCLoginPrep" && r.URL.Path != "/api/OIDCLogin" {
 		var ok bool
-		isExport := r.URL.Path == "/export"
+		isExport := r.URL.Path == "/export" || r.URL.Path == "/takeout"
 		requireCSRF := isAPI || r.URL.Path == "/import" || isExport
 		accName, sessionToken, loginAddress, ok = webauth.Check(ctx, log, webauth.Accounts, "webaccount", isForwarded, w, r, isAPI, requireCSRF, isExport)
 		if !ok {
@@ -236,6 +257,9 @@ func handle(apiHandler http.Handler, isForwarded bool, w http.ResponseWriter, r
 	case "/export":
 		webops.Export(log, accName, w, r)
 
+	case "/takeout":
+		webops.Takeout(log, accName, w, r)
+
 	case "/import":
 		if r.Method != "POST" {
 			http.Error(w, "405 - method not allowed - post required", http.StatusMethodNotAllowed)
@@ -326,12 +350,14 @@ func (w Account) LoginPrep(ctx context.Context) string {
 }
 
 // Login returns a session token for the credentials, or fails with error code
-// "user:badLogin". Call LoginPrep to get a loginToken.
-func (w Account) Login(ctx context.Context, loginToken, username, password string) store.CSRFToken {
+// "user:badLogin". Call LoginPrep to get a loginToken. If two-factor
+// authentication is enabled and totpCode is empty, the error code is
+// "user:totpRequired", and the login must be retried with a code.
+func (w Account) Login(ctx context.Context, loginToken, username, password, totpCode string) store.CSRFToken {
 	log := pkglog.WithContext(ctx)
 	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
 
-	csrfToken, err := webauth.Login(ctx, log, webauth.Accounts, "webaccount", w.cookiePath, w.isForwarded, reqInfo.Response, reqInfo.Request, loginToken, username, password)
+	csrfToken, err := webauth.Login(ctx, log, webauth.Accounts, "webaccount", w.cookiePath, w.isForwarded, reqInfo.Response, reqInfo.Request, loginToken, username, password, totpCode)
 	if _, ok := err.(*sherpa.Error); ok {
 		panic(err)
 	}
@@ -339,6 +365,64 @@ func (w Account) Login(ctx context.Context, loginToken, username, password strin
 	return csrfToken
 }
 
+// PasskeyLoginPrep returns options for logging in with a passkey with
+// navigator.credentials.get in the browser.
+func (w Account) PasskeyLoginPrep(ctx context.Context) webauth.PasskeyRequestOptions {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	return webauth.PasskeyLoginPrep("webaccount", w.isForwarded, reqInfo.Request)
+}
+
+// PasskeyLogin returns a session token for a login with a passkey, started with
+// PasskeyLoginPrep, or fails with error code "user:loginFailed". Call LoginPrep
+// to get a loginToken after the user selected a passkey.
+func (w Account) PasskeyLogin(ctx context.Context, loginToken string, response webauth.PasskeyAssertion) store.CSRFToken {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	csrfToken, err := webauth.PasskeyLogin(ctx, log, webauth.Accounts, "webaccount", w.cookiePath, w.isForwarded, reqInfo.Response, reqInfo.Request, loginToken, response)
+	if _, ok := err.(*sherpa.Error); ok {
+		panic(err)
+	}
+	xcheckf(ctx, err, "passkey login")
+	return csrfToken
+}
+
+// OIDCEnabled returns whether single sign-on through an OpenID Connect identity
+// provider is configured.
+func (Account) OIDCEnabled(ctx context.Context) bool {
+	return webauth.OIDCEnabled()
+}
+
+// OIDCLoginPrep starts a single sign-on login, returning the URL of the identity
+// provider to navigate to. The identity provider sends the browser back to this
+// page with "code" and "state" query string parameters, for OIDCLogin.
+func (w Account) OIDCLoginPrep(ctx context.Context) string {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	authURL, err := webauth.OIDCLoginPrep(ctx, log, "webaccount", w.cookiePath, w.isForwarded, reqInfo.Response, reqInfo.Request)
+	if _, ok := err.(*sherpa.Error); ok {
+		panic(err)
+	}
+	xcheckf(ctx, err, "starting single sign-on")
+	return authURL
+}
+
+// OIDCLogin returns a session token for a single sign-on login started with
+// OIDCLoginPrep, or fails with error code "user:loginFailed". Call LoginPrep to
+// get a loginToken.
+func (w Account) OIDCLogin(ctx context.Context, loginToken, code, state string) store.CSRFToken {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	csrfToken, err := webauth.OIDCLogin(ctx, log, webauth.Accounts, "webaccount", w.cookiePath, w.isForwarded, reqInfo.Response, reqInfo.Request, loginToken, code, state)
+	if _, ok := err.(*sherpa.Error); ok {
+		panic(err)
+	}
+	xcheckf(ctx, err, "single sign-on login")
+	return csrfToken
+}
+
 // Logout invalidates the session token.
 func (w Account) Logout(ctx context.Context) {
 	log := pkglog.WithContext(ctx)
@@ -350,14 +434,19 @@ func (w Account) Logout(ctx context.Context) {
 
 // SetPassword saves a new password for the account, invalidating the previous password.
 // Sessions are not interrupted, and will keep working. New login attempts must use the new password.
-// Password must be at least 8 characters.
+// Password must be at least 8 characters, and meet the password policy.
 func (Account) SetPassword(ctx context.Context, password string) {
 	log := pkglog.WithContext(ctx)
-	if len(password) < 8 {
-		panic(&sherpa.Error{Code: "user:error", Message: "password must be at least 8 characters"})
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	if _, auth := store.ExternalAuth(reqInfo.LoginAddress); auth != nil {
+		panic(&sherpa.Error{Code: "user:error", Message: "password is verified by external authentication, change it there"})
+	}
+	err := mox.PasswordPolicyCheck(ctx, log, reqInfo.AccountName, password)
+	if errors.Is(err, mox.ErrPasswordPolicy) {
+		xcheckuserf(ctx, err, "checking password")
 	}
+	xcheckf(ctx, err, "checking password")
 
-	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
 	acc, err := store.OpenAccount(log, reqInfo.AccountName)
 	xcheckf(ctx, err, "open account")
 	defer func() {
@@ -377,6 +466,57 @@ func (Account) SetPassword(ctx context.Context, password string) {
 	xcheckf(ctx, err, "restoring session after password reset")
 }
 
+// PasswordStatus returns whether an admin requires the password to be changed,
+// and the requirements for new passwords from the password policy.
+func (Account) PasswordStatus(ctx context.Context) (changeRequired bool, minLength, minEntropy int, breachCheck bool) {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	changeRequired, err = acc.PasswordChangeRequired(ctx)
+	xcheckf(ctx, err, "get password status")
+
+	minLength = 8
+	if p := mox.AccountPasswordPolicy(reqInfo.AccountName); p != nil {
+		minLength = max(minLength, p.MinLength)
+		minEntropy = p.MinEntropy
+		breachCheck = p.BreachCheck
+	}
+	return
+}
+
+// AccountDelete requests deletion of the account, after verifying the password.
+// Logins are disabled immediately, and the session is ended. The account and all
+// its data are removed at purgeAfter, unless an admin cancels the deletion before.
+func (w Account) AccountDelete(ctx context.Context, password string) (purgeAfter time.Time) {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	acc, err := store.OpenEmailAuth(log, reqInfo.LoginAddress, password)
+	if err != nil && errors.Is(err, store.ErrUnknownCredentials) {
+		panic(&sherpa.Error{Code: "user:error", Message: "incorrect password"})
+	}
+	xcheckf(ctx, err, "verifying password")
+	err = acc.Close()
+	log.Check(err, "closing account")
+	if acc.Name != reqInfo.AccountName {
+		panic(&sherpa.Error{Code: "user:error", Message: "incorrect password"})
+	}
+
+	d, err := accountdel.Request(ctx, log, reqInfo.AccountName, "account", accountdel.DefaultGrace)
+	xcheckuserf(ctx, err, "requesting account deletion")
+
+	err = webauth.Logout(ctx, log, webauth.Accounts, "webaccount", w.cookiePath, w.isForwarded, reqInfo.Response, reqInfo.Request, reqInfo.AccountName, reqInfo.SessionToken)
+	log.Check(err, "logout after requesting account deletion")
+	return d.PurgeAfter
+}
+
 // Account returns information about the account.
 // StorageUsed is the sum of the sizes of all messages, in bytes.
 // StorageLimit is the maximum storage that can be used, or 0 if there is no limit.
@@ -411,6 +551,25 @@ func (Account) Account(ctx context.Context) (account config.Account, storageUsed
 	return accConf, storageUsed, storageLimit, suppressions
 }
 
+// ClientSettings returns the settings for configuring email, calendar and
+// contacts applications for an address of the account, with setup instructions
+// for common applications.
+func (Account) ClientSettings(ctx context.Context, address string) mox.ClientSettings {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	accConf, ok := mox.Conf.Account(reqInfo.AccountName)
+	if !ok {
+		xcheckf(ctx, errors.New("account not found"), "looking up account")
+	}
+	if _, ok := accConf.Destinations[address]; !ok || strings.HasPrefix(address, "@") {
+		xcheckuserf(ctx, errors.New("not an address of the account"), "looking up address")
+	}
+	addr, err := smtp.ParseAddress(address)
+	xcheckuserf(ctx, err, "parsing address")
+	cs, err := mox.ClientSettingsAddress(addr)
+	xcheckf(ctx, err, "gathering client settings")
+	return cs
+}
+
 // AccountSaveFullName saves the full name (used as display name in email messages)
 // for the account.
 func (Account) AccountSaveFullName(ctx context.Context, fullName string) {
@@ -421,6 +580,52 @@ func (Account) AccountSaveFullName(ctx context.Context, fullName string) {
 	xcheckf(ctx, err, "saving account full name")
 }
 
+// Language returns the language configured for the account, used for the web
+// interfaces and messages generated by mox. Empty means the browser default.
+func (Account) Language(ctx context.Context) (language string) {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	settings := store.Settings{ID: 1}
+	err = acc.DB.Get(ctx, &settings)
+	xcheckf(ctx, err, "get settings")
+	return settings.Language
+}
+
+// LanguageSave saves the language for the account, empty for the browser default.
+func (Account) LanguageSave(ctx context.Context, language string) {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	if language != "" && !i18n.Known(language) {
+		xcheckuserf(ctx, errors.New("unknown language"), "checking language")
+	}
+
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	err = acc.DB.Write(ctx, func(tx *bstore.Tx) error {
+		settings := store.Settings{ID: 1}
+		if err := tx.Get(&settings); err != nil {
+			return err
+		}
+		settings.Language = language
+		return tx.Update(&settings)
+	})
+	xcheckf(ctx, err, "saving language")
+}
+
 // DestinationSave updates a destination.
 // OldDest is compared against the current destination. If it does not match, an
 // error is returned. Otherwise newDest is saved and the configuration reloaded.
@@ -460,6 +665,43 @@ func (Account) ImportAbort(ctx context.Context, importToken string) error {
 	return <-req.Response
 }
 
+// ImportIMAP starts a migration of all mailboxes and messages from an account
+// at a remote IMAP server, with tlsMode "tls" or "starttls". Progress can be
+// tracked and the migration aborted like an import. Messages added before an
+// abort are kept. Starting a migration again continues where an earlier one
+// stopped, adds new messages and updates flags of migrated messages. Hosts with
+// internal IPs are refused.
+func (Account) ImportIMAP(ctx context.Context, host, tlsMode, username, password, prefix string) ImportProgress {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	if host == "" || username == "" {
+		xcheckuserf(ctx, errors.New("host and username are required"), "checking parameters")
+	}
+	mode := imapmigrate.TLSMode(tlsMode)
+	if mode != imapmigrate.TLSImmediate && mode != imapmigrate.TLSStartTLS {
+		xcheckuserf(ctx, fmt.Errorf("unknown tls mode %q", tlsMode), "checking parameters")
+	}
+	prefix = strings.Trim(prefix, "/")
+	if prefix != "" {
+		_, _, err := store.CheckMailboxName(prefix, false)
+		xcheckuserf(ctx, err, "checking mailbox prefix")
+	}
+
+	remote := imapmigrate.Remote{Host: host, TLSMode: mode, Username: username, Password: password, Dial: importIMAPDial}
+	token, err := importIMAPStart(log, reqInfo.AccountName, remote, prefix)
+	xcheckf(ctx, err, "starting migration")
+	return ImportProgress{Token: token}
+}
+
+// importIMAPDial connects to the remote IMAP server for a migration. Migrations
+// are started by account users, so connections to internal IPs are refused, the
+// server can't be used to reach services on the local network.
+func importIMAPDial(ctx context.Context, addr string) (net.Conn, error) {
+	d := net.Dialer{Timeout: 30 * time.Second, Control: mox.PublicDialControl}
+	return d.DialContext(ctx, "tcp", addr)
+}
+
 // Types exposes types not used in API method signatures, such as the import form upload.
 func (Account) Types() (importProgress ImportProgress) {
 	return
@@ -639,6 +881,90 @@ func (Account) AutomaticJunkFlagsSave(ctx context.Context, enabled bool, junkReg
 	xcheckf(ctx, err, "saving account automatic junk flags")
 }
 
+// JunkFilterTrainingSave saves whether retraining the junk filter after changes
+// to junk/nonjunk flags of messages is delayed until the daily retraining of the
+// backlog.
+func (Account) JunkFilterTrainingSave(ctx context.Context, delay bool) {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	err := mox.AccountSave(ctx, reqInfo.AccountName, func(acc *config.Account) {
+		if acc.JunkFilter == nil {
+			return
+		}
+		jf := *acc.JunkFilter
+		jf.DelayFlagTraining = delay
+		acc.JunkFilter = &jf
+	})
+	if err != nil && errors.Is(err, mox.ErrConfig) {
+		xcheckuserf(ctx, err, "saving account junk filter training settings")
+	}
+	xcheckf(ctx, err, "saving account junk filter training settings")
+}
+
+// JunkFilterRetrainBacklog retrains the junk filter with messages whose
+// junk/nonjunk flags changed since they were last trained. Returns the number of
+// retrained messages.
+func (Account) JunkFilterRetrainBacklog(ctx context.Context) (retrained int) {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	n, err := acc.RetrainBacklog(ctx, log)
+	if err != nil && errors.Is(err, store.ErrNoJunkFilter) {
+		xcheckuserf(ctx, err, "retraining junk filter")
+	}
+	xcheckf(ctx, err, "retraining junk filter")
+	return n
+}
+
+// Quarantined returns the messages for the account held in quarantine, most
+// recent first.
+func (Account) Quarantined(ctx context.Context) []admindb.Quarantined {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	l, err := admindb.QuarantineList(ctx, reqInfo.AccountName)
+	xcheckf(ctx, err, "listing quarantined messages")
+	return l
+}
+
+// QuarantineHeaders returns the header section of a quarantined message.
+func (Account) QuarantineHeaders(ctx context.Context, id int64) string {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	s, err := quarantine.Headers(ctx, reqInfo.AccountName, id)
+	if errors.Is(err, admindb.ErrNotFound) {
+		xcheckuserf(ctx, err, "reading quarantined message")
+	}
+	xcheckf(ctx, err, "reading quarantined message")
+	return s
+}
+
+// QuarantineRelease delivers a quarantined message to the mailbox it was destined
+// for.
+func (Account) QuarantineRelease(ctx context.Context, id int64) {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	err := quarantine.Release(ctx, log, reqInfo.AccountName, id)
+	if errors.Is(err, admindb.ErrNotFound) || errors.Is(err, store.ErrOverQuota) {
+		xcheckuserf(ctx, err, "releasing quarantined message")
+	}
+	xcheckf(ctx, err, "releasing quarantined message")
+}
+
+// QuarantineRemove removes a quarantined message without delivering it.
+func (Account) QuarantineRemove(ctx context.Context, id int64) {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	err := quarantine.Remove(ctx, log, reqInfo.AccountName, id)
+	if errors.Is(err, admindb.ErrNotFound) {
+		xcheckuserf(ctx, err, "removing quarantined message")
+	}
+	xcheckf(ctx, err, "removing quarantined message")
+}
+
 // RejectsSave saves the RejectsMailbox and KeepRejects settings.
 func (Account) RejectsSave(ctx context.Context, mailbox string, keep bool) {
 	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
@@ -651,3 +977,831 @@ func (Account) RejectsSave(ctx context.Context, mailbox string, keep bool) {
 	}
 	xcheckf(ctx, err, "saving account rejects settings")
 }
+
+// ProtocolSessions returns the active and recent IMAP and SMTP submission
+// sessions of the account, e.g. to see which devices are using the account.
+func (Account) ProtocolSessions(ctx context.Context) []store.ProtocolSession {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	l, err := acc.ProtocolSessions(ctx)
+	xcheckf(ctx, err, "listing sessions")
+	return l
+}
+
+// ProtocolSessionClose closes an active IMAP or SMTP submission session, e.g.
+// of a lost device. If id is 0, all active sessions are closed. The device can
+// log in again unless the password is changed.
+func (Account) ProtocolSessionClose(ctx context.Context, id int64) (closed int) {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	closed, err = acc.ProtocolSessionClose(log, id)
+	if errors.Is(err, store.ErrSessionUnknown) {
+		xcheckuserf(ctx, err, "closing session")
+	}
+	xcheckf(ctx, err, "closing session")
+	return closed
+}
+
+// AppPasswords returns the app passwords of the account. App passwords can be
+// used for IMAP and SMTP submission, not for the web interfaces.
+func (Account) AppPasswords(ctx context.Context) []store.AppPassword {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	l, err := acc.AppPasswords(ctx)
+	xcheckf(ctx, err, "listing app passwords")
+	return l
+}
+
+// AppPasswordAdd adds a new app password with a random password. The password is
+// only returned now, it cannot be retrieved later. Protocols can be "imap" and/or
+// "smtp", empty means all. If ipNets is non-empty, authentication is only allowed
+// from those IPs/networks.
+func (Account) AppPasswordAdd(ctx context.Context, label string, protocols, ipNets []string) (appPassword store.AppPassword, password string) {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	appPassword, password, err = acc.AppPasswordAdd(ctx, label, protocols, ipNets)
+	if errors.Is(err, store.ErrAppPasswordParam) {
+		xcheckuserf(ctx, err, "adding app password")
+	}
+	xcheckf(ctx, err, "adding app password")
+	return appPassword, password
+}
+
+// AppPasswordRemove removes an app password. Sessions that authenticated with the
+// app password are not closed, see ProtocolSessionClose.
+func (Account) AppPasswordRemove(ctx context.Context, id int64) {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	err = acc.AppPasswordRemove(ctx, id)
+	if err == bstore.ErrAbsent {
+		xcheckuserf(ctx, err, "removing app password")
+	}
+	xcheckf(ctx, err, "removing app password")
+}
+
+// TOTPStatus returns whether two-factor authentication with time-based one-time
+// passwords is enabled for logging in to the web interfaces, whether it is
+// required by the account or domain configuration, and the number of unused
+// recovery codes.
+func (Account) TOTPStatus(ctx context.Context) (enabled, required bool, recoveryCodesLeft int) {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	enabled, recoveryCodesLeft, err = acc.TOTPStatus(ctx)
+	xcheckf(ctx, err, "get two-factor authentication status")
+	return enabled, webauth.TOTPRequired(acc), recoveryCodesLeft
+}
+
+// TOTPSetup starts setting up two-factor authentication. The returned secret (in
+// base32), otpauth URI and QR code (PNG as data URL, of the URI) can be added to
+// an authenticator app. Setup must be finished with TOTPEnable.
+func (Account) TOTPSetup(ctx context.Context) (secret, uri, qrcodeDataURL string) {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	buf, err := acc.TOTPSetup(ctx)
+	if errors.Is(err, store.ErrTOTPEnabled) {
+		xcheckuserf(ctx, err, "setting up two-factor authentication")
+	}
+	xcheckf(ctx, err, "setting up two-factor authentication")
+
+	issuer := reqInfo.LoginAddress
+	if t := strings.Split(issuer, "@"); len(t) == 2 {
+		issuer = t[1]
+	}
+	uri = totp.URI(issuer, reqInfo.LoginAddress, buf)
+	code, err := qr.Encode(uri, qr.L)
+	xcheckf(ctx, err, "generating qr code")
+	qrcodeDataURL = "data:image/png;base64," + base64.StdEncoding.EncodeToString(code.PNG())
+	return totp.EncodeSecret(buf), uri, qrcodeDataURL
+}
+
+// TOTPEnable finishes setup of two-factor authentication by verifying a code from
+// the authenticator app. Recovery codes are returned, each can be used once
+// instead of a code from the authenticator app, e.g. when the device is lost.
+// Once enabled, the account password can no longer be used for IMAP and SMTP
+// submission, app passwords must be used instead.
+func (Account) TOTPEnable(ctx context.Context, code string) (recoveryCodes []string) {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	recoveryCodes, err = acc.TOTPEnable(ctx, code)
+	if errors.Is(err, store.ErrTOTPEnabled) || errors.Is(err, store.ErrTOTPNotPending) || errors.Is(err, store.ErrTOTPInvalid) {
+		xcheckuserf(ctx, err, "enabling two-factor authentication")
+	}
+	xcheckf(ctx, err, "enabling two-factor authentication")
+	return recoveryCodes
+}
+
+// TOTPRecoveryCodesReset generates new recovery codes, invalidating all previous
+// recovery codes.
+func (Account) TOTPRecoveryCodesReset(ctx context.Context) (recoveryCodes []string) {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	recoveryCodes, err = acc.TOTPRecoveryCodesReset(ctx)
+	if errors.Is(err, store.ErrTOTPNotEnabled) {
+		xcheckuserf(ctx, err, "resetting recovery codes")
+	}
+	xcheckf(ctx, err, "resetting recovery codes")
+	return recoveryCodes
+}
+
+// TOTPDisable disables two-factor authentication, after verifying a code from the
+// authenticator app or a recovery code. Not allowed if two-factor authentication
+// is required by the configuration.
+func (Account) TOTPDisable(ctx context.Context, code string) {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	if webauth.TOTPRequired(acc) {
+		xcheckuserf(ctx, errors.New("required by configuration"), "disabling two-factor authentication")
+	}
+	err = acc.TOTPCheck(ctx, code)
+	if errors.Is(err, store.ErrTOTPNotEnabled) || errors.Is(err, store.ErrTOTPInvalid) {
+		xcheckuserf(ctx, err, "disabling two-factor authentication")
+	}
+	xcheckf(ctx, err, "verifying code")
+	err = acc.TOTPDisable(ctx)
+	xcheckf(ctx, err, "disabling two-factor authentication")
+}
+
+// Passkeys returns the passkeys of the account, for logging in to the account and
+// webmail web interfaces without password.
+func (Account) Passkeys(ctx context.Context) []store.Passkey {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	l, err := acc.Passkeys(ctx)
+	xcheckf(ctx, err, "listing passkeys")
+	return l
+}
+
+// PasskeyRegisterPrep returns options for registering a new passkey with
+// navigator.credentials.create in the browser. Finish registration with
+// PasskeyRegister.
+func (w Account) PasskeyRegisterPrep(ctx context.Context) webauth.PasskeyCreationOptions {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	// The account name is returned as user handle when logging in, which is limited
+	// in size.
+	if len(reqInfo.AccountName) > 64 {
+		xcheckuserf(ctx, errors.New("account name too long for passkeys"), "registering passkey")
+	}
+
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	l, err := acc.Passkeys(ctx)
+	xcheckf(ctx, err, "listing passkeys")
+	var exclude []string
+	for _, p := range l {
+		exclude = append(exclude, p.CredentialID)
+	}
+	return webauth.PasskeyRegisterPrep("webaccount", w.isForwarded, reqInfo.Request, reqInfo.AccountName, reqInfo.AccountName, reqInfo.LoginAddress, exclude)
+}
+
+// PasskeyRegister finishes registration of a passkey started with
+// PasskeyRegisterPrep, with the response of navigator.credentials.create. Logins
+// with the passkey get the login address of the current session.
+func (Account) PasskeyRegister(ctx context.Context, label string, response webauth.PasskeyAttestation) store.Passkey {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	cred, err := webauth.PasskeyRegister("webaccount", reqInfo.AccountName, response)
+	xcheckuserf(ctx, err, "verifying passkey registration")
+
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	p, err := acc.PasskeyAdd(ctx, label, reqInfo.LoginAddress, cred)
+	if errors.Is(err, store.ErrPasskeyParam) {
+		xcheckuserf(ctx, err, "adding passkey")
+	}
+	xcheckf(ctx, err, "adding passkey")
+	return p
+}
+
+// PasskeyRemove removes a passkey. Existing sessions are not affected.
+func (Account) PasskeyRemove(ctx context.Context, id int64) {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	err = acc.PasskeyRemove(ctx, id)
+	if err == bstore.ErrAbsent {
+		xcheckuserf(ctx, err, "removing passkey")
+	}
+	xcheckf(ctx, err, "removing passkey")
+}
+
+// PushSubscriptions returns the Web Push subscriptions of webmail on devices, for
+// notifications about new messages.
+func (Account) PushSubscriptions(ctx context.Context) []store.PushSubscription {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	l, err := acc.PushSubscriptions(ctx)
+	xcheckf(ctx, err, "listing push subscriptions")
+	return l
+}
+
+// PushSubscriptionRemove removes a Web Push subscription. The device no longer
+// gets notifications.
+func (Account) PushSubscriptionRemove(ctx context.Context, id int64) {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	err = acc.PushSubscriptionRemove(ctx, id)
+	if err == bstore.ErrAbsent {
+		xcheckuserf(ctx, err, "removing push subscription")
+	}
+	xcheckf(ctx, err, "removing push subscription")
+}
+
+// PushSubscriptionTest sends a test notification to a Web Push subscription.
+func (Account) PushSubscriptionTest(ctx context.Context, id int64) {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	ps := store.PushSubscription{ID: id}
+	err = acc.DB.Get(ctx, &ps)
+	if err == bstore.ErrAbsent {
+		xcheckuserf(ctx, err, "get push subscription")
+	}
+	xcheckf(ctx, err, "get push subscription")
+
+	n := webpush.Notification{Title: "Test notification", Body: "Notifications work.", URL: "#Inbox", Tag: "test"}
+	err = webpush.Push(ctx, log, acc, ps, n)
+	xcheckuserf(ctx, err, "sending test notification")
+}
+
+// RemoteContentSenders returns the senders for which external resources in HTML
+// messages are loaded by default in webmail. Senders are email addresses, or
+// domains as "@domain".
+func (Account) RemoteContentSenders(ctx context.Context) []string {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	q := bstore.QueryDB[store.FromAddressSettings](ctx, acc.DB)
+	q.FilterNonzero(store.FromAddressSettings{ViewMode: store.ModeHTMLExt})
+	q.SortAsc("FromAddress")
+	l, err := q.List()
+	xcheckf(ctx, err, "listing senders")
+	senders := []string{}
+	for _, fas := range l {
+		senders = append(senders, fas.FromAddress)
+	}
+	return senders
+}
+
+// xremoteContentSender parses a sender, an email address or "@domain", returning
+// it in the form stored in the from address settings.
+func xremoteContentSender(ctx context.Context, sender string) string {
+	if s, ok := strings.CutPrefix(sender, "@"); ok {
+		d, err := dns.ParseDomain(s)
+		xcheckuserf(ctx, err, "parsing domain")
+		return "@" + d.Name()
+	}
+	addr, err := smtp.ParseAddress(sender)
+	xcheckuserf(ctx, err, "parsing address")
+	return addr.Pack(true)
+}
+
+// RemoteContentSenderAdd adds a sender, an email address or "@domain", for which
+// external resources in HTML messages are loaded by default in webmail.
+func (Account) RemoteContentSenderAdd(ctx context.Context, sender string) {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	sender = xremoteContentSender(ctx, sender)
+
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	err = acc.DB.Write(ctx, func(tx *bstore.Tx) error {
+		fas := store.FromAddressSettings{FromAddress: sender}
+		if err := tx.Get(&fas); err == bstore.ErrAbsent {
+			fas.ViewMode = store.ModeHTMLExt
+			return tx.Insert(&fas)
+		} else if err != nil {
+			return err
+		}
+		fas.ViewMode = store.ModeHTMLExt
+		return tx.Update(&fas)
+	})
+	xcheckf(ctx, err, "adding sender")
+}
+
+// RemoteContentSenderRemove removes a sender from the senders for which
+// external resources are loaded by default.
+func (Account) RemoteContentSenderRemove(ctx context.Context, sender string) {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	err = acc.DB.Write(ctx, func(tx *bstore.Tx) error {
+		fas := store.FromAddressSettings{FromAddress: sender}
+		if err := tx.Get(&fas); err != nil {
+			return err
+		} else if fas.ViewMode != store.ModeHTMLExt {
+			return bstore.ErrAbsent
+		}
+		return tx.Delete(&fas)
+	})
+	if err == bstore.ErrAbsent {
+		xcheckuserf(ctx, err, "removing sender")
+	}
+	xcheckf(ctx, err, "removing sender")
+}
+
+// SenderList returns the allow/deny sender lists of the account, evaluated
+// during incoming delivery.
+func (Account) SenderList(ctx context.Context) []store.SenderListEntry {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	l, err := acc.SenderList(ctx)
+	xcheckf(ctx, err, "listing sender list")
+	return l
+}
+
+// SenderListSet adds a sender, an email address or "@domain", to the sender
+// lists with action "allow", "reject" or "junk", replacing the action for a
+// sender already present.
+func (Account) SenderListSet(ctx context.Context, sender string, action store.SenderAction) store.SenderListEntry {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	e, err := acc.SenderListSet(ctx, sender, action)
+	if errors.Is(err, store.ErrSenderListParam) {
+		xcheckuserf(ctx, err, "saving sender list entry")
+	}
+	xcheckf(ctx, err, "saving sender list entry")
+	return e
+}
+
+// SenderListRemove removes an entry from the sender lists.
+func (Account) SenderListRemove(ctx context.Context, id int64) {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	err = acc.SenderListRemove(ctx, id)
+	if err == bstore.ErrAbsent {
+		xcheckuserf(ctx, err, "removing sender list entry")
+	}
+	xcheckf(ctx, err, "removing sender list entry")
+}
+
+// ownedAliases returns the addresses of the aliases created by the account
+// through the account web interface.
+func ownedAliases(accountName string) []string {
+	l := []string{}
+	for _, d := range mox.Conf.DynamicConfig().Domains {
+		for _, a := range d.Aliases {
+			if a.Owner != accountName {
+				continue
+			}
+			if lp, err := smtp.ParseLocalpart(a.LocalpartStr); err == nil {
+				l = append(l, smtp.NewAddress(lp, a.Domain).Pack(true))
+			}
+		}
+	}
+	slices.Sort(l)
+	return l
+}
+
+// AliasesOwned returns the addresses of aliases created by the account itself,
+// and the maximum number of aliases the account can create.
+func (Account) AliasesOwned(ctx context.Context) (aliases []string, maxAliases int) {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	accConf, ok := mox.Conf.Account(reqInfo.AccountName)
+	if !ok {
+		xcheckf(ctx, errors.New("account not found"), "looking up account")
+	}
+	return ownedAliases(reqInfo.AccountName), accConf.MaxAliases
+}
+
+// AliasCreate creates a new alias in a domain of one of the addresses of the
+// account, with member as only member. Member must be an address of the account.
+// The number of aliases an account can create is limited by MaxAliases in its
+// configuration.
+func (Account) AliasCreate(ctx context.Context, localpart, domain, member string, allowMsgFrom bool) {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	accConf, ok := mox.Conf.Account(reqInfo.AccountName)
+	if !ok {
+		xcheckf(ctx, errors.New("account not found"), "looking up account")
+	}
+	if len(ownedAliases(reqInfo.AccountName)) >= accConf.MaxAliases {
+		xcheckuserf(ctx, fmt.Errorf("maximum of %d aliases reached", accConf.MaxAliases), "creating alias")
+	}
+
+	lp, err := smtp.ParseLocalpart(localpart)
+	xcheckuserf(ctx, err, "parsing localpart")
+	d, err := dns.ParseDomain(domain)
+	xcheckuserf(ctx, err, "parsing domain")
+	memberAddr, err := smtp.ParseAddress(member)
+	xcheckuserf(ctx, err, "parsing member address")
+
+	// Domain must be a domain of the account, and member an address of the account.
+	var domainOK, memberOK bool
+	for dest := range accConf.Destinations {
+		if s, ok := strings.CutPrefix(dest, "@"); ok {
+			if dd, err := dns.ParseDomain(s); err == nil && dd == d {
+				domainOK = true
+			}
+			continue
+		}
+		addr, err := smtp.ParseAddress(dest)
+		if err != nil {
+			continue
+		}
+		domainOK = domainOK || addr.Domain == d
+		memberOK = memberOK || addr == memberAddr
+	}
+	if !domainOK {
+		xcheckuserf(ctx, errors.New("account has no addresses in domain"), "checking domain")
+	}
+	if !memberOK {
+		xcheckuserf(ctx, errors.New("member is not an address of the account"), "checking member")
+	}
+
+	domConf, ok := mox.Conf.Domain(d)
+	if !ok {
+		xcheckuserf(ctx, errors.New("domain not found"), "looking up domain")
+	}
+	if domConf.LocalpartCatchallSeparator != "" && strings.Contains(string(lp), domConf.LocalpartCatchallSeparator) {
+		xcheckuserf(ctx, errors.New("localpart contains catchall separator"), "checking localpart")
+	}
+	addr := smtp.NewAddress(lp, d)
+	if _, _, ok := mox.Conf.AccountDestination(smtp.NewAddress(mox.CanonicalLocalpart(lp, domConf), d).Pack(true)); ok {
+		xcheckuserf(ctx, errors.New("address already exists"), "checking address")
+	}
+
+	alias := config.Alias{
+		Addresses:    []string{memberAddr.Pack(true)},
+		AllowMsgFrom: allowMsgFrom,
+		Owner:        reqInfo.AccountName,
+	}
+	err = mox.AliasAdd(ctx, addr, alias)
+	if errors.Is(err, mox.ErrRequest) {
+		xcheckuserf(ctx, err, "adding alias")
+	}
+	xcheckf(ctx, err, "adding alias")
+}
+
+// AliasDelete removes an alias created by the account.
+func (Account) AliasDelete(ctx context.Context, address string) {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	addr, err := smtp.ParseAddress(address)
+	xcheckuserf(ctx, err, "parsing address")
+	domConf, ok := mox.Conf.Domain(addr.Domain)
+	if !ok {
+		xcheckuserf(ctx, errors.New("domain not found"), "looking up domain")
+	}
+	if a, ok := domConf.Aliases[addr.Localpart.String()]; !ok || a.Owner != reqInfo.AccountName {
+		xcheckuserf(ctx, errors.New("no alias created by account"), "looking up alias")
+	}
+	err = mox.AliasRemove(ctx, addr)
+	xcheckf(ctx, err, "removing alias")
+}
+
+// Identities returns the sending identities of the account, with settings per
+// address used by webmail when composing messages.
+func (Account) Identities(ctx context.Context) []store.Identity {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	l, err := acc.Identities(ctx)
+	xcheckf(ctx, err, "listing identities")
+	return l
+}
+
+// IdentitySave stores the settings for sending from an address, replacing any
+// existing identity for the address. The address must be an address of the
+// account, or an alias that allows sending with its address.
+func (Account) IdentitySave(ctx context.Context, identity store.Identity) store.Identity {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	addr, err := smtp.ParseAddress(identity.Address)
+	xcheckuserf(ctx, err, "parsing address")
+	identity.Address = addr.Pack(true)
+	identity.FullName = strings.TrimSpace(identity.FullName)
+	if identity.ReplyTo != "" {
+		replyTo, err := smtp.ParseAddress(identity.ReplyTo)
+		xcheckuserf(ctx, err, "parsing reply-to address")
+		identity.ReplyTo = replyTo.Pack(true)
+	}
+
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	accConf, _ := acc.Conf()
+	var known bool
+	for dest := range accConf.Destinations {
+		if a, err := smtp.ParseAddress(dest); err == nil && a == addr {
+			known = true
+			break
+		}
+	}
+	for _, a := range accConf.Aliases {
+		lp, err := smtp.ParseLocalpart(a.Alias.LocalpartStr)
+		if err == nil && a.Alias.AllowMsgFrom && smtp.NewAddress(lp, a.Alias.Domain) == addr {
+			known = true
+		}
+	}
+	if !known {
+		xcheckuserf(ctx, errors.New("not an address of the account"), "checking address")
+	}
+
+	err = acc.IdentitySave(ctx, &identity)
+	xcheckf(ctx, err, "saving identity")
+	return identity
+}
+
+// IdentityRemove removes an identity.
+func (Account) IdentityRemove(ctx context.Context, id int64) {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	err = acc.IdentityRemove(ctx, id)
+	if err == bstore.ErrAbsent {
+		xcheckuserf(ctx, err, "removing identity")
+	}
+	xcheckf(ctx, err, "removing identity")
+}
+
+// Contacts returns the contacts in the address book of the account, sorted by
+// name. Includes contacts harvested from recipients of sent messages.
+func (Account) Contacts(ctx context.Context) []store.Contact {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	l, err := acc.Contacts(ctx)
+	xcheckf(ctx, err, "listing contacts")
+	return l
+}
+
+// ContactSave adds a new contact if ID is zero, or updates an existing contact.
+// The saved contact is returned.
+func (Account) ContactSave(ctx context.Context, contact store.Contact) store.Contact {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	contact, err = acc.ContactSave(ctx, contact)
+	if errors.Is(err, store.ErrContactParam) || err == bstore.ErrAbsent {
+		xcheckuserf(ctx, err, "saving contact")
+	}
+	xcheckf(ctx, err, "saving contact")
+	return contact
+}
+
+// ContactRemove removes a contact.
+func (Account) ContactRemove(ctx context.Context, id int64) {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	err = acc.ContactRemove(ctx, id)
+	if err == bstore.ErrAbsent {
+		xcheckuserf(ctx, err, "removing contact")
+	}
+	xcheckf(ctx, err, "removing contact")
+}
+
+// ContactsImport adds contacts from one or more vCards, e.g. exported from another
+// address book. Existing contacts with the same UID are updated. The number of
+// added/updated contacts is returned.
+func (Account) ContactsImport(ctx context.Context, vcards string) (count int) {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	count, err = acc.ContactsImport(ctx, strings.NewReader(vcards))
+	if errors.Is(err, store.ErrContactParam) {
+		xcheckuserf(ctx, err, "importing contacts")
+	}
+	xcheckf(ctx, err, "importing contacts")
+	return count
+}
+
+// ContactsExport returns all contacts as vCards.
+func (Account) ContactsExport(ctx context.Context) (vcards string) {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	acc, err := store.OpenAccount(log, reqInfo.AccountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	var b strings.Builder
+	err = acc.ContactsExport(ctx, &b)
+	xcheckf(ctx, err, "exporting contacts")
+	return b.String()
+}
@@ -226,6 +226,68 @@ const [dom, style, attr, prop] = (function () {
 	const prop = (x) => { return { _props: x }; };
 	return [dom, style, attr, prop];
 })();
+// Translations of the user interface. Text is looked up by its English version
+// with _(), which replaces placeholders "{0}", "{1}", etc. with parameters. The
+// language pack is fetched with i18nLoad, before building the user interface.
+let i18nLang = 'en';
+let i18nLocale; // For formatting dates/times, undefined for the browser default.
+let i18nLanguages = [{ Code: 'en', Name: 'English' }];
+let i18nTranslations = {};
+const _ = (s, ...args) => {
+	s = i18nTranslations[s] || s;
+	args.forEach((a, i) => {
+		s = s.split('{' + i + '}').join('' + a);
+	});
+	return s;
+};
+// i18nPreference returns the language explicitly chosen by the user, or an empty
+// string if the languages configured in the browser are used.
+const i18nPreference = () => {
+	try {
+		return window.localStorage.getItem('language') || '';
+	}
+	catch (err) {
+		return '';
+	}
+};
+// i18nPreferenceSet stores the language chosen by the user, an empty string
+// switches back to the browser languages. The page must be reloaded for a new
+// language to be used.
+const i18nPreferenceSet = (lang) => {
+	try {
+		if (lang) {
+			window.localStorage.setItem('language', lang);
+		}
+		else {
+			window.localStorage.removeItem('language');
+		}
+	}
+	catch (err) {
+		console.log('storing language preference', err);
+	}
+};
+// i18nLoad fetches the language pack for the preferred language. On failure,
+// English is used.
+const i18nLoad = async () => {
+	const browserLangs = navigator.languages || [navigator.language];
+	const lang = [i18nPreference(), ...browserLangs].filter(s => s).join(',');
+	try {
+		const resp = await fetch('i18n.json?lang=' + encodeURIComponent(lang));
+		if (!resp.ok) {
+			throw new Error('http status ' + resp.status);
+		}
+		const p = await resp.json();
+		i18nLang = p.Language;
+		i18nLanguages = p.Languages;
+		i18nTranslations = p.Translations || {};
+	}
+	catch (err) {
+		console.log('loading language pack, continuing with english', err);
+	}
+	// Format dates with the regional variant from the browser, e.g. "nl-BE".
+	i18nLocale = browserLangs.find(s => s.split('-')[0].toLowerCase() === i18nLang) || (i18nPreference() ? i18nLang : undefined);
+	document.documentElement.lang = i18nLang;
+};
 // NOTE: GENERATED by github.com/mjl-/sherpats, DO NOT MODIFY
 var api;
 (function (api) {
@@ -255,11 +317,21 @@ var api;
 		// per-outgoing-message address used for sending.
 		OutgoingEvent["EventUnrecognized"] = "unrecognized";
 	})(OutgoingEvent = api.OutgoingEvent || (api.OutgoingEvent = {}));
-	api.structTypes = { "Account": true, "Address": true, "AddressAlias": true, "Alias": true, "AliasAddress": true, "AutomaticJunkFlags": true, "Destination": true, "Domain": true, "ImportProgress": true, "Incoming": true, "IncomingMeta": true, "IncomingWebhook": true, "JunkFilter": true, "NameAddress": true, "Outgoing": true, "OutgoingWebhook": true, "Route": true, "Ruleset": true, "Structure": true, "SubjectPass": true, "Suppression": true };
-	api.stringsTypes = { "CSRFToken": true, "Localpart": true, "OutgoingEvent": true };
+	// SenderAction is the action for incoming messages from a sender in the
+	// allow/deny sender lists.
+	let SenderAction;
+	(function (SenderAction) {
+		SenderAction["SenderAllow"] = "allow";
+		SenderAction["SenderReject"] = "reject";
+		SenderAction["SenderJunk"] = "junk";
+	})(SenderAction = api.SenderAction || (api.SenderAction = {}));
+	api.structTypes = { "Account": true, "Address": true, "AddressAlias": true, "Alias": true, "AliasAddress": true, "AppPassword": true, "AutomaticJunkFlags": true, "ClientInstructions": true, "ClientServer": true, "ClientSettings": true, "Contact": true, "Destination": true, "Domain": true, "Footer": true, "Identity": true, "ImportProgress": true, "Incoming": true, "IncomingMeta": true, "IncomingWebhook": true, "JunkFilter": true, "NameAddress": true, "Outgoing": true, "OutgoingWebhook": true, "Passkey": true, "PasskeyAssertion": true, "PasskeyAttestation": true, "PasskeyCreationOptions": true, "PasskeyRequestOptions": true, "PasswordPolicy": true, "ProtocolSession": true, "PushSubscription": true, "Quarantined": true, "Route": true, "Ruleset": true, "SenderListEntry": true, "Structure": true, "SubjectPass": true, "Suppression": true };
+	api.stringsTypes = { "CSRFToken": true, "Localpart": true, "OutgoingEvent": true, "SenderAction": true };
 	api.intsTypes = {};
 	api.types = {
-		"Account": { "Name": "Account", "Docs": "", "Fields": [{ "Name": "OutgoingWebhook", "Docs": "", "Typewords": ["nullable", "OutgoingWebhook"] }, { "Name": "IncomingWebhook", "Docs": "", "Typewords": ["nullable", "IncomingWebhook"] }, { "Name": "FromIDLoginAddresses", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "KeepRetiredMessagePeriod", "Docs": "", "Typewords": ["int64"] }, { "Name": "KeepRetiredWebhookPeriod", "Docs": "", "Typewords": ["int64"] }, { "Name": "Domain", "Docs": "", "Typewords": ["string"] }, { "Name": "Description", "Docs": "", "Typewords": ["string"] }, { "Name": "FullName", "Docs": "", "Typewords": ["string"] }, { "Name": "Destinations", "Docs": "", "Typewords": ["{}", "Destination"] }, { "Name": "SubjectPass", "Docs": "", "Typewords": ["SubjectPass"] }, { "Name": "QuotaMessageSize", "Docs": "", "Typewords": ["int64"] }, { "Name": "RejectsMailbox", "Docs": "", "Typewords": ["string"] }, { "Name": "KeepRejects", "Docs": "", "Typewords": ["bool"] }, { "Name": "AutomaticJunkFlags", "Docs": "", "Typewords": ["AutomaticJunkFlags"] }, { "Name": "JunkFilter", "Docs": "", "Typewords": ["nullable", "JunkFilter"] }, { "Name": "MaxOutgoingMessagesPerDay", "Docs": "", "Typewords": ["int32"] }, { "Name": "MaxFirstTimeRecipientsPerDay", "Docs": "", "Typewords": ["int32"] }, { "Name": "NoFirstTimeSenderDelay", "Docs": "", "Typewords": ["bool"] }, { "Name": "Routes", "Docs": "", "Typewords": ["[]", "Route"] }, { "Name": "DNSDomain", "Docs": "", "Typewords": ["Domain"] }, { "Name": "Aliases", "Docs": "", "Typewords": ["[]", "AddressAlias"] }] },
+		"PasskeyRequestOptions": { "Name": "PasskeyRequestOptions", "Docs": "", "Fields": [{ "Name": "Challenge", "Docs": "", "Typewords": ["string"] }, { "Name": "RPID", "Docs": "", "Typewords": ["string"] }, { "Name": "Timeout", "Docs": "", "Typewords": ["int32"] }] },
+		"PasskeyAssertion": { "Name": "PasskeyAssertion", "Docs": "", "Fields": [{ "Name": "CredentialID", "Docs": "", "Typewords": ["string"] }, { "Name": "ClientDataJSON", "Docs": "", "Typewords": ["string"] }, { "Name": "AuthenticatorData", "Docs": "", "Typewords": ["string"] }, { "Name": "Signature", "Docs": "", "Typewords": ["string"] }, { "Name": "UserHandle", "Docs": "", "Typewords": ["string"] }] },
+		"Account": { "Name": "Account", "Docs": "", "Fields": [{ "Name": "OutgoingWebhook", "Docs": "", "Typewords": ["nullable", "OutgoingWebhook"] }, { "Name": "IncomingWebhook", "Docs": "", "Typewords": ["nullable", "IncomingWebhook"] }, { "Name": "FromIDLoginAddresses", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "KeepRetiredMessagePeriod", "Docs": "", "Typewords": ["int64"] }, { "Name": "KeepRetiredWebhookPeriod", "Docs": "", "Typewords": ["int64"] }, { "Name": "Domain", "Docs": "", "Typewords": ["string"] }, { "Name": "Description", "Docs": "", "Typewords": ["string"] }, { "Name": "FullName", "Docs": "", "Typewords": ["string"] }, { "Name": "Destinations", "Docs": "", "Typewords": ["{}", "Destination"] }, { "Name": "SubjectPass", "Docs": "", "Typewords": ["SubjectPass"] }, { "Name": "QuotaMessageSize", "Docs": "", "Typewords": ["int64"] }, { "Name": "CompressMessages", "Docs": "", "Typewords": ["bool"] }, { "Name": "RejectsMailbox", "Docs": "", "Typewords": ["string"] }, { "Name": "KeepRejects", "Docs": "", "Typewords": ["bool"] }, { "Name": "AutomaticJunkFlags", "Docs": "", "Typewords": ["AutomaticJunkFlags"] }, { "Name": "JunkFilter", "Docs": "", "Typewords": ["nullable", "JunkFilter"] }, { "Name": "MaxOutgoingMessagesPerDay", "Docs": "", "Typewords": ["int32"] }, { "Name": "MaxFirstTimeRecipientsPerDay", "Docs": "", "Typewords": ["int32"] }, { "Name": "MaxAliases", "Docs": "", "Typewords": ["int32"] }, { "Name": "NoFirstTimeSenderDelay", "Docs": "", "Typewords": ["bool"] }, { "Name": "RequireTOTP", "Docs": "", "Typewords": ["bool"] }, { "Name": "LoginDisabled", "Docs": "", "Typewords": ["string"] }, { "Name": "Routes", "Docs": "", "Typewords": ["[]", "Route"] }, { "Name": "Footer", "Docs": "", "Typewords": ["nullable", "Footer"] }, { "Name": "PasswordPolicy", "Docs": "", "Typewords": ["nullable", "PasswordPolicy"] }, { "Name": "DNSDomain", "Docs": "", "Typewords": ["Domain"] }, { "Name": "Aliases", "Docs": "", "Typewords": ["[]", "AddressAlias"] }] },
 		"OutgoingWebhook": { "Name": "OutgoingWebhook", "Docs": "", "Fields": [{ "Name": "URL", "Docs": "", "Typewords": ["string"] }, { "Name": "Authorization", "Docs": "", "Typewords": ["string"] }, { "Name": "Events", "Docs": "", "Typewords": ["[]", "string"] }] },
 		"IncomingWebhook": { "Name": "IncomingWebhook", "Docs": "", "Fields": [{ "Name": "URL", "Docs": "", "Typewords": ["string"] }, { "Name": "Authorization", "Docs": "", "Typewords": ["string"] }] },
 		"Destination": { "Name": "Destination", "Docs": "", "Fields": [{ "Name": "Mailbox", "Docs": "", "Typewords": ["string"] }, { "Name": "Rulesets", "Docs": "", "Typewords": ["[]", "Ruleset"] }, { "Name": "FullName", "Docs": "", "Typewords": ["string"] }] },
@@ -267,24 +339,42 @@ var api;
 		"Domain": { "Name": "Domain", "Docs": "", "Fields": [{ "Name": "ASCII", "Docs": "", "Typewords": ["string"] }, { "Name": "Unicode", "Docs": "", "Typewords": ["string"] }] },
 		"SubjectPass": { "Name": "SubjectPass", "Docs": "", "Fields": [{ "Name": "Period", "Docs": "", "Typewords": ["int64"] }] },
 		"AutomaticJunkFlags": { "Name": "AutomaticJunkFlags", "Docs": "", "Fields": [{ "Name": "Enabled", "Docs": "", "Typewords": ["bool"] }, { "Name": "JunkMailboxRegexp", "Docs": "", "Typewords": ["string"] }, { "Name": "NeutralMailboxRegexp", "Docs": "", "Typewords": ["string"] }, { "Name": "NotJunkMailboxRegexp", "Docs": "", "Typewords": ["string"] }] },
-		"JunkFilter": { "Name": "JunkFilter", "Docs": "", "Fields": [{ "Name": "Threshold", "Docs": "", "Typewords": ["float64"] }, { "Name": "Onegrams", "Docs": "", "Typewords": ["bool"] }, { "Name": "Twograms", "Docs": "", "Typewords": ["bool"] }, { "Name": "Threegrams", "Docs": "", "Typewords": ["bool"] }, { "Name": "MaxPower", "Docs": "", "Typewords": ["float64"] }, { "Name": "TopWords", "Docs": "", "Typewords": ["int32"] }, { "Name": "IgnoreWords", "Docs": "", "Typewords": ["float64"] }, { "Name": "RareWords", "Docs": "", "Typewords": ["int32"] }] },
+		"JunkFilter": { "Name": "JunkFilter", "Docs": "", "Fields": [{ "Name": "Threshold", "Docs": "", "Typewords": ["float64"] }, { "Name": "SharedWeight", "Docs": "", "Typewords": ["float64"] }, { "Name": "ContributeShared", "Docs": "", "Typewords": ["bool"] }, { "Name": "DelayFlagTraining", "Docs": "", "Typewords": ["bool"] }, { "Name": "Onegrams", "Docs": "", "Typewords": ["bool"] }, { "Name": "Twograms", "Docs": "", "Typewords": ["bool"] }, { "Name": "Threegrams", "Docs": "", "Typewords": ["bool"] }, { "Name": "MaxPower", "Docs": "", "Typewords": ["float64"] }, { "Name": "TopWords", "Docs": "", "Typewords": ["int32"] }, { "Name": "IgnoreWords", "Docs": "", "Typewords": ["float64"] }, { "Name": "RareWords", "Docs": "", "Typewords": ["int32"] }] },
 		"Route": { "Name": "Route", "Docs": "", "Fields": [{ "Name": "FromDomain", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "ToDomain", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "MinimumAttempts", "Docs": "", "Typewords": ["int32"] }, { "Name": "Transport", "Docs": "", "Typewords": ["string"] }, { "Name": "FromDomainASCII", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "ToDomainASCII", "Docs": "", "Typewords": ["[]", "string"] }] },
+		"Footer": { "Name": "Footer", "Docs": "", "Fields": [{ "Name": "Text", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "HTML", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "SkipReplies", "Docs": "", "Typewords": ["bool"] }] },
+		"PasswordPolicy": { "Name": "PasswordPolicy", "Docs": "", "Fields": [{ "Name": "MinLength", "Docs": "", "Typewords": ["int32"] }, { "Name": "MinEntropy", "Docs": "", "Typewords": ["int32"] }, { "Name": "DenyList", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "DenyListFile", "Docs": "", "Typewords": ["string"] }, { "Name": "BreachCheck", "Docs": "", "Typewords": ["bool"] }, { "Name": "BreachCheckURL", "Docs": "", "Typewords": ["string"] }] },
 		"AddressAlias": { "Name": "AddressAlias", "Docs": "", "Fields": [{ "Name": "SubscriptionAddress", "Docs": "", "Typewords": ["string"] }, { "Name": "Alias", "Docs": "", "Typewords": ["Alias"] }, { "Name": "MemberAddresses", "Docs": "", "Typewords": ["[]", "string"] }] },
-		"Alias": { "Name": "Alias", "Docs": "", "Fields": [{ "Name": "Addresses", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "PostPublic", "Docs": "", "Typewords": ["bool"] }, { "Name": "ListMembers", "Docs": "", "Typewords": ["bool"] }, { "Name": "AllowMsgFrom", "Docs": "", "Typewords": ["bool"] }, { "Name": "LocalpartStr", "Docs": "", "Typewords": ["string"] }, { "Name": "Domain", "Docs": "", "Typewords": ["Domain"] }, { "Name": "ParsedAddresses", "Docs": "", "Typewords": ["[]", "AliasAddress"] }] },
+		"Alias": { "Name": "Alias", "Docs": "", "Fields": [{ "Name": "Addresses", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "PostPublic", "Docs": "", "Typewords": ["bool"] }, { "Name": "ListMembers", "Docs": "", "Typewords": ["bool"] }, { "Name": "AllowMsgFrom", "Docs": "", "Typewords": ["bool"] }, { "Name": "Owner", "Docs": "", "Typewords": ["string"] }, { "Name": "OwnerAddress", "Docs": "", "Typewords": ["string"] }, { "Name": "Moderated", "Docs": "", "Typewords": ["bool"] }, { "Name": "LocalpartStr", "Docs": "", "Typewords": ["string"] }, { "Name": "Domain", "Docs": "", "Typewords": ["Domain"] }, { "Name": "ParsedAddresses", "Docs": "", "Typewords": ["[]", "AliasAddress"] }, { "Name": "ExternalAddresses", "Docs": "", "Typewords": ["[]", "Address"] }, { "Name": "ParsedOwner", "Docs": "", "Typewords": ["AliasAddress"] }] },
 		"AliasAddress": { "Name": "AliasAddress", "Docs": "", "Fields": [{ "Name": "Address", "Docs": "", "Typewords": ["Address"] }, { "Name": "AccountName", "Docs": "", "Typewords": ["string"] }, { "Name": "Destination", "Docs": "", "Typewords": ["Destination"] }] },
 		"Address": { "Name": "Address", "Docs": "", "Fields": [{ "Name": "Localpart", "Docs": "", "Typewords": ["Localpart"] }, { "Name": "Domain", "Docs": "", "Typewords": ["Domain"] }] },
 		"Suppression": { "Name": "Suppression", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Created", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Account", "Docs": "", "Typewords": ["string"] }, { "Name": "BaseAddress", "Docs": "", "Typewords": ["string"] }, { "Name": "OriginalAddress", "Docs": "", "Typewords": ["string"] }, { "Name": "Manual", "Docs": "", "Typewords": ["bool"] }, { "Name": "Reason", "Docs": "", "Typewords": ["string"] }] },
+		"ClientSettings": { "Name": "ClientSettings", "Docs": "", "Fields": [{ "Name": "Address", "Docs": "", "Typewords": ["string"] }, { "Name": "IMAP", "Docs": "", "Typewords": ["ClientServer"] }, { "Name": "Submission", "Docs": "", "Typewords": ["ClientServer"] }, { "Name": "Account", "Docs": "", "Typewords": ["string"] }, { "Name": "Webmail", "Docs": "", "Typewords": ["string"] }, { "Name": "WebAPI", "Docs": "", "Typewords": ["string"] }, { "Name": "DAV", "Docs": "", "Typewords": ["string"] }, { "Name": "Autoconfig", "Docs": "", "Typewords": ["string"] }, { "Name": "Autodiscover", "Docs": "", "Typewords": ["string"] }, { "Name": "MobileConfig", "Docs": "", "Typewords": ["string"] }, { "Name": "Clients", "Docs": "", "Typewords": ["[]", "ClientInstructions"] }] },
+		"ClientServer": { "Name": "ClientServer", "Docs": "", "Fields": [{ "Name": "Host", "Docs": "", "Typewords": ["string"] }, { "Name": "Port", "Docs": "", "Typewords": ["int32"] }, { "Name": "Security", "Docs": "", "Typewords": ["string"] }] },
+		"ClientInstructions": { "Name": "ClientInstructions", "Docs": "", "Fields": [{ "Name": "Client", "Docs": "", "Typewords": ["string"] }, { "Name": "Steps", "Docs": "", "Typewords": ["[]", "string"] }] },
 		"ImportProgress": { "Name": "ImportProgress", "Docs": "", "Fields": [{ "Name": "Token", "Docs": "", "Typewords": ["string"] }] },
 		"Outgoing": { "Name": "Outgoing", "Docs": "", "Fields": [{ "Name": "Version", "Docs": "", "Typewords": ["int32"] }, { "Name": "Event", "Docs": "", "Typewords": ["OutgoingEvent"] }, { "Name": "DSN", "Docs": "", "Typewords": ["bool"] }, { "Name": "Suppressing", "Docs": "", "Typewords": ["bool"] }, { "Name": "QueueMsgID", "Docs": "", "Typewords": ["int64"] }, { "Name": "FromID", "Docs": "", "Typewords": ["string"] }, { "Name": "MessageID", "Docs": "", "Typewords": ["string"] }, { "Name": "Subject", "Docs": "", "Typewords": ["string"] }, { "Name": "WebhookQueued", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "SMTPCode", "Docs": "", "Typewords": ["int32"] }, { "Name": "SMTPEnhancedCode", "Docs": "", "Typewords": ["string"] }, { "Name": "Error", "Docs": "", "Typewords": ["string"] }, { "Name": "Extra", "Docs": "", "Typewords": ["{}", "string"] }] },
 		"Incoming": { "Name": "Incoming", "Docs": "", "Fields": [{ "Name": "Version", "Docs": "", "Typewords": ["int32"] }, { "Name": "From", "Docs": "", "Typewords": ["[]", "NameAddress"] }, { "Name": "To", "Docs": "", "Typewords": ["[]", "NameAddress"] }, { "Name": "CC", "Docs": "", "Typewords": ["[]", "NameAddress"] }, { "Name": "BCC", "Docs": "", "Typewords": ["[]", "NameAddress"] }, { "Name": "ReplyTo", "Docs": "", "Typewords": ["[]", "NameAddress"] }, { "Name": "Subject", "Docs": "", "Typewords": ["string"] }, { "Name": "MessageID", "Docs": "", "Typewords": ["string"] }, { "Name": "InReplyTo", "Docs": "", "Typewords": ["string"] }, { "Name": "References", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Date", "Docs": "", "Typewords": ["nullable", "timestamp"] }, { "Name": "Text", "Docs": "", "Typewords": ["string"] }, { "Name": "HTML", "Docs": "", "Typewords": ["string"] }, { "Name": "Structure", "Docs": "", "Typewords": ["Structure"] }, { "Name": "Meta", "Docs": "", "Typewords": ["IncomingMeta"] }] },
 		"NameAddress": { "Name": "NameAddress", "Docs": "", "Fields": [{ "Name": "Name", "Docs": "", "Typewords": ["string"] }, { "Name": "Address", "Docs": "", "Typewords": ["string"] }] },
 		"Structure": { "Name": "Structure", "Docs": "", "Fields": [{ "Name": "ContentType", "Docs": "", "Typewords": ["string"] }, { "Name": "ContentTypeParams", "Docs": "", "Typewords": ["{}", "string"] }, { "Name": "ContentID", "Docs": "", "Typewords": ["string"] }, { "Name": "DecodedSize", "Docs": "", "Typewords": ["int64"] }, { "Name": "Parts", "Docs": "", "Typewords": ["[]", "Structure"] }] },
 		"IncomingMeta": { "Name": "IncomingMeta", "Docs": "", "Fields": [{ "Name": "MsgID", "Docs": "", "Typewords": ["int64"] }, { "Name": "MailFrom", "Docs": "", "Typewords": ["string"] }, { "Name": "MailFromValidated", "Docs": "", "Typewords": ["bool"] }, { "Name": "MsgFromValidated", "Docs": "", "Typewords": ["bool"] }, { "Name": "RcptTo", "Docs": "", "Typewords": ["string"] }, { "Name": "DKIMVerifiedDomains", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "RemoteIP", "Docs": "", "Typewords": ["string"] }, { "Name": "Received", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "MailboxName", "Docs": "", "Typewords": ["string"] }, { "Name": "Automated", "Docs": "", "Typewords": ["bool"] }] },
+		"Quarantined": { "Name": "Quarantined", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Received", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Expires", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Account", "Docs": "", "Typewords": ["string"] }, { "Name": "Mailbox", "Docs": "", "Typewords": ["string"] }, { "Name": "Reason", "Docs": "", "Typewords": ["string"] }, { "Name": "RemoteIP", "Docs": "", "Typewords": ["string"] }, { "Name": "MailFrom", "Docs": "", "Typewords": ["string"] }, { "Name": "RcptTo", "Docs": "", "Typewords": ["string"] }, { "Name": "MsgFrom", "Docs": "", "Typewords": ["string"] }, { "Name": "Subject", "Docs": "", "Typewords": ["string"] }, { "Name": "MessageID", "Docs": "", "Typewords": ["string"] }, { "Name": "Size", "Docs": "", "Typewords": ["int64"] }, { "Name": "Digested", "Docs": "", "Typewords": ["timestamp"] }] },
+		"ProtocolSession": { "Name": "ProtocolSession", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Protocol", "Docs": "", "Typewords": ["string"] }, { "Name": "LoginAddress", "Docs": "", "Typewords": ["string"] }, { "Name": "RemoteIP", "Docs": "", "Typewords": ["string"] }, { "Name": "ClientID", "Docs": "", "Typewords": ["string"] }, { "Name": "Started", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "LastActivity", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Ended", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Active", "Docs": "", "Typewords": ["bool"] }, { "Name": "Closed", "Docs": "", "Typewords": ["bool"] }] },
+		"AppPassword": { "Name": "AppPassword", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Label", "Docs": "", "Typewords": ["string"] }, { "Name": "Created", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "LastUsed", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Protocols", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "IPNets", "Docs": "", "Typewords": ["[]", "string"] }] },
+		"Passkey": { "Name": "Passkey", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Label", "Docs": "", "Typewords": ["string"] }, { "Name": "LoginAddress", "Docs": "", "Typewords": ["string"] }, { "Name": "Created", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "LastUsed", "Docs": "", "Typewords": ["timestamp"] }] },
+		"PasskeyCreationOptions": { "Name": "PasskeyCreationOptions", "Docs": "", "Fields": [{ "Name": "Challenge", "Docs": "", "Typewords": ["string"] }, { "Name": "RPID", "Docs": "", "Typewords": ["string"] }, { "Name": "RPName", "Docs": "", "Typewords": ["string"] }, { "Name": "UserID", "Docs": "", "Typewords": ["string"] }, { "Name": "UserName", "Docs": "", "Typewords": ["string"] }, { "Name": "UserDisplayName", "Docs": "", "Typewords": ["string"] }, { "Name": "ExcludeCredentialIDs", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Algorithms", "Docs": "", "Typewords": ["[]", "int32"] }, { "Name": "Timeout", "Docs": "", "Typewords": ["int32"] }] },
+		"PasskeyAttestation": { "Name": "PasskeyAttestation", "Docs": "", "Fields": [{ "Name": "ClientDataJSON", "Docs": "", "Typewords": ["string"] }, { "Name": "AttestationObject", "Docs": "", "Typewords": ["string"] }] },
+		"PushSubscription": { "Name": "PushSubscription", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Created", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Endpoint", "Docs": "", "Typewords": ["string"] }, { "Name": "P256DH", "Docs": "", "Typewords": ["string"] }, { "Name": "Auth", "Docs": "", "Typewords": ["string"] }, { "Name": "Label", "Docs": "", "Typewords": ["string"] }, { "Name": "LastPush", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "LastError", "Docs": "", "Typewords": ["string"] }] },
+		"SenderListEntry": { "Name": "SenderListEntry", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Sender", "Docs": "", "Typewords": ["string"] }, { "Name": "Action", "Docs": "", "Typewords": ["SenderAction"] }, { "Name": "Created", "Docs": "", "Typewords": ["timestamp"] }] },
+		"Identity": { "Name": "Identity", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Address", "Docs": "", "Typewords": ["string"] }, { "Name": "FullName", "Docs": "", "Typewords": ["string"] }, { "Name": "ReplyTo", "Docs": "", "Typewords": ["string"] }, { "Name": "Signature", "Docs": "", "Typewords": ["string"] }] },
+		"Contact": { "Name": "Contact", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "UID", "Docs": "", "Typewords": ["string"] }, { "Name": "Href", "Docs": "", "Typewords": ["string"] }, { "Name": "Created", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Updated", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Name", "Docs": "", "Typewords": ["string"] }, { "Name": "Emails", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Phones", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Organization", "Docs": "", "Typewords": ["string"] }, { "Name": "Notes", "Docs": "", "Typewords": ["string"] }, { "Name": "Harvested", "Docs": "", "Typewords": ["bool"] }] },
 		"CSRFToken": { "Name": "CSRFToken", "Docs": "", "Values": null },
 		"Localpart": { "Name": "Localpart", "Docs": "", "Values": null },
 		"OutgoingEvent": { "Name": "OutgoingEvent", "Docs": "", "Values": [{ "Name": "EventDelivered", "Value": "delivered", "Docs": "" }, { "Name": "EventSuppressed", "Value": "suppressed", "Docs": "" }, { "Name": "EventDelayed", "Value": "delayed", "Docs": "" }, { "Name": "EventFailed", "Value": "failed", "Docs": "" }, { "Name": "EventRelayed", "Value": "relayed", "Docs": "" }, { "Name": "EventExpanded", "Value": "expanded", "Docs": "" }, { "Name": "EventCanceled", "Value": "canceled", "Docs": "" }, { "Name": "EventUnrecognized", "Value": "unrecognized", "Docs": "" }] },
+		"SenderAction": { "Name": "SenderAction", "Docs": "", "Values": [{ "Name": "SenderAllow", "Value": "allow", "Docs": "" }, { "Name": "SenderReject", "Value": "reject", "Docs": "" }, { "Name": "SenderJunk", "Value": "junk", "Docs": "" }] },
 	};
 	api.parser = {
+		PasskeyRequestOptions: (v) => api.parse("PasskeyRequestOptions", v),
+		PasskeyAssertion: (v) => api.parse("PasskeyAssertion", v),
 		Account: (v) => api.parse("Account", v),
 		OutgoingWebhook: (v) => api.parse("OutgoingWebhook", v),
 		IncomingWebhook: (v) => api.parse("IncomingWebhook", v),
@@ -295,20 +385,36 @@ var api;
 		AutomaticJunkFlags: (v) => api.parse("AutomaticJunkFlags", v),
 		JunkFilter: (v) => api.parse("JunkFilter", v),
 		Route: (v) => api.parse("Route", v),
+		Footer: (v) => api.parse("Footer", v),
+		PasswordPolicy: (v) => api.parse("PasswordPolicy", v),
 		AddressAlias: (v) => api.parse("AddressAlias", v),
 		Alias: (v) => api.parse("Alias", v),
 		AliasAddress: (v) => api.parse("AliasAddress", v),
 		Address: (v) => api.parse("Address", v),
 		Suppression: (v) => api.parse("Suppression", v),
+		ClientSettings: (v) => api.parse("ClientSettings", v),
+		ClientServer: (v) => api.parse("ClientServer", v),
+		ClientInstructions: (v) => api.parse("ClientInstructions", v),
 		ImportProgress: (v) => api.parse("ImportProgress", v),
 		Outgoing: (v) => api.parse("Outgoing", v),
 		Incoming: (v) => api.parse("Incoming", v),
 		NameAddress: (v) => api.parse("NameAddress", v),
 		Structure: (v) => api.parse("Structure", v),
 		IncomingMeta: (v) => api.parse("IncomingMeta", v),
+		Quarantined: (v) => api.parse("Quarantined", v),
+		ProtocolSession: (v) => api.parse("ProtocolSession", v),
+		AppPassword: (v) => api.parse("AppPassword", v),
+		Passkey: (v) => api.parse("Passkey", v),
+		PasskeyCreationOptions: (v) => api.parse("PasskeyCreationOptions", v),
+		PasskeyAttestation: (v) => api.parse("PasskeyAttestation", v),
+		PushSubscription: (v) => api.parse("PushSubscription", v),
+		SenderListEntry: (v) => api.parse("SenderListEntry", v),
+		Identity: (v) => api.parse("Identity", v),
+		Contact: (v) => api.parse("Contact", v),
 		CSRFToken: (v) => api.parse("CSRFToken", v),
 		Localpart: (v) => api.parse("Localpart", v),
 		OutgoingEvent: (v) => api.parse("OutgoingEvent", v),
+		SenderAction: (v) => api.parse("SenderAction", v),
 	};
 	// Account exports web API functions for the account web interface. All its
 	// methods are exported under api/. Function calls require valid HTTP
@@ -345,12 +451,62 @@ var api;
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
 		// Login returns a session token for the credentials, or fails with error code
-		// "user:badLogin". Call LoginPrep to get a loginToken.
-		async Login(loginToken, username, password) {
+		// "user:badLogin". Call LoginPrep to get a loginToken. If two-factor
+		// authentication is enabled and totpCode is empty, the error code is
+		// "user:totpRequired", and the login must be retried with a code.
+		async Login(loginToken, username, password, totpCode) {
 			const fn = "Login";
+			const paramTypes = [["string"], ["string"], ["string"], ["string"]];
+			const returnTypes = [["CSRFToken"]];
+			const params = [loginToken, username, password, totpCode];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// PasskeyLoginPrep returns options for logging in with a passkey with
+		// navigator.credentials.get in the browser.
+		async PasskeyLoginPrep() {
+			const fn = "PasskeyLoginPrep";
+			const paramTypes = [];
+			const returnTypes = [["PasskeyRequestOptions"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// PasskeyLogin returns a session token for a login with a passkey, started with
+		// PasskeyLoginPrep, or fails with error code "user:loginFailed". Call LoginPrep
+		// to get a loginToken after the user selected a passkey.
+		async PasskeyLogin(loginToken, response) {
+			const fn = "PasskeyLogin";
+			const paramTypes = [["string"], ["PasskeyAssertion"]];
+			const returnTypes = [["CSRFToken"]];
+			const params = [loginToken, response];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// OIDCEnabled returns whether single sign-on through an OpenID Connect identity
+		// provider is configured.
+		async OIDCEnabled() {
+			const fn = "OIDCEnabled";
+			const paramTypes = [];
+			const returnTypes = [["bool"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// OIDCLoginPrep starts a single sign-on login, returning the URL of the identity
+		// provider to navigate to. The identity provider sends the browser back to this
+		// page with "code" and "state" query string parameters, for OIDCLogin.
+		async OIDCLoginPrep() {
+			const fn = "OIDCLoginPrep";
+			const paramTypes = [];
+			const returnTypes = [["string"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// OIDCLogin returns a session token for a single sign-on login started with
+		// OIDCLoginPrep, or fails with error code "user:loginFailed". Call LoginPrep to
+		// get a loginToken.
+		async OIDCLogin(loginToken, code, state) {
+			const fn = "OIDCLogin";
 			const paramTypes = [["string"], ["string"], ["string"]];
 			const returnTypes = [["CSRFToken"]];
-			const params = [loginToken, username, password];
+			const params = [loginToken, code, state];
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
 		// Logout invalidates the session token.
@@ -363,7 +519,7 @@ var api;
 		}
 		// SetPassword saves a new password for the account, invalidating the previous password.
 		// Sessions are not interrupted, and will keep working. New login attempts must use the new password.
-		// Password must be at least 8 characters.
+		// Password must be at least 8 characters, and meet the password policy.
 		async SetPassword(password) {
 			const fn = "SetPassword";
 			const paramTypes = [["string"]];
@@ -371,6 +527,25 @@ var api;
 			const params = [password];
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
+		// PasswordStatus returns whether an admin requires the password to be changed,
+		// and the requirements for new passwords from the password policy.
+		async PasswordStatus() {
+			const fn = "PasswordStatus";
+			const paramTypes = [];
+			const returnTypes = [["bool"], ["int32"], ["int32"], ["bool"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// AccountDelete requests deletion of the account, after verifying the password.
+		// Logins are disabled immediately, and the session is ended. The account and all
+		// its data are removed at purgeAfter, unless an admin cancels the deletion before.
+		async AccountDelete(password) {
+			const fn = "AccountDelete";
+			const paramTypes = [["string"]];
+			const returnTypes = [["timestamp"]];
+			const params = [password];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
 		// Account returns information about the account.
 		// StorageUsed is the sum of the sizes of all messages, in bytes.
 		// StorageLimit is the maximum storage that can be used, or 0 if there is no limit.
@@ -381,6 +556,16 @@ var api;
 			const params = [];
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
+		// ClientSettings returns the settings for configuring email, calendar and
+		// contacts applications for an address of the account, with setup instructions
+		// for common applications.
+		async ClientSettings(address) {
+			const fn = "ClientSettings";
+			const paramTypes = [["string"]];
+			const returnTypes = [["ClientSettings"]];
+			const params = [address];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
 		// AccountSaveFullName saves the full name (used as display name in email messages)
 		// for the account.
 		async AccountSaveFullName(fullName) {
@@ -390,6 +575,23 @@ var api;
 			const params = [fullName];
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
+		// Language returns the language configured for the account, used for the web
+		// interfaces and messages generated by mox. Empty means the browser default.
+		async Language() {
+			const fn = "Language";
+			const paramTypes = [];
+			const returnTypes = [["string"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// LanguageSave saves the language for the account, empty for the browser default.
+		async LanguageSave(language) {
+			const fn = "LanguageSave";
+			const paramTypes = [["string"]];
+			const returnTypes = [];
+			const params = [language];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
 		// DestinationSave updates a destination.
 		// OldDest is compared against the current destination. If it does not match, an
 		// error is returned. Otherwise newDest is saved and the configuration reloaded.
@@ -409,6 +611,19 @@ var api;
 			const params = [importToken];
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
+		// ImportIMAP starts a migration of all mailboxes and messages from an account
+		// at a remote IMAP server, with tlsMode "tls" or "starttls". Progress can be
+		// tracked and the migration aborted like an import. Messages added before an
+		// abort are kept. Starting a migration again continues where an earlier one
+		// stopped, adds new messages and updates flags of migrated messages. Hosts with
+		// internal IPs are refused.
+		async ImportIMAP(host, tlsMode, username, password, prefix) {
+			const fn = "ImportIMAP";
+			const paramTypes = [["string"], ["string"], ["string"], ["string"], ["string"]];
+			const returnTypes = [["ImportProgress"]];
+			const params = [host, tlsMode, username, password, prefix];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
 		// Types exposes types not used in API method signatures, such as the import form upload.
 		async Types() {
 			const fn = "Types";
@@ -508,6 +723,60 @@ var api;
 			const params = [enabled, junkRegexp, neutralRegexp, notJunkRegexp];
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
+		// JunkFilterTrainingSave saves whether retraining the junk filter after changes
+		// to junk/nonjunk flags of messages is delayed until the daily retraining of the
+		// backlog.
+		async JunkFilterTrainingSave(delay) {
+			const fn = "JunkFilterTrainingSave";
+			const paramTypes = [["bool"]];
+			const returnTypes = [];
+			const params = [delay];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// JunkFilterRetrainBacklog retrains the junk filter with messages whose
+		// junk/nonjunk flags changed since they were last trained. Returns the number of
+		// retrained messages.
+		async JunkFilterRetrainBacklog() {
+			const fn = "JunkFilterRetrainBacklog";
+			const paramTypes = [];
+			const returnTypes = [["int32"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// Quarantined returns the messages for the account held in quarantine, most
+		// recent first.
+		async Quarantined() {
+			const fn = "Quarantined";
+			const paramTypes = [];
+			const returnTypes = [["[]", "Quarantined"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// QuarantineHeaders returns the header section of a quarantined message.
+		async QuarantineHeaders(id) {
+			const fn = "QuarantineHeaders";
+			const paramTypes = [["int64"]];
+			const returnTypes = [["string"]];
+			const params = [id];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// QuarantineRelease delivers a quarantined message to the mailbox it was destined
+		// for.
+		async QuarantineRelease(id) {
+			const fn = "QuarantineRelease";
+			const paramTypes = [["int64"]];
+			const returnTypes = [];
+			const params = [id];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// QuarantineRemove removes a quarantined message without delivering it.
+		async QuarantineRemove(id) {
+			const fn = "QuarantineRemove";
+			const paramTypes = [["int64"]];
+			const returnTypes = [];
+			const params = [id];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
 		// RejectsSave saves the RejectsMailbox and KeepRejects settings.
 		async RejectsSave(mailbox, keep) {
 			const fn = "RejectsSave";
@@ -516,6 +785,323 @@ var api;
 			const params = [mailbox, keep];
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
+		// ProtocolSessions returns the active and recent IMAP and SMTP submission
+		// sessions of the account, e.g. to see which devices are using the account.
+		async ProtocolSessions() {
+			const fn = "ProtocolSessions";
+			const paramTypes = [];
+			const returnTypes = [["[]", "ProtocolSession"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// ProtocolSessionClose closes an active IMAP or SMTP submission session, e.g.
+		// of a lost device. If id is 0, all active sessions are closed. The device can
+		// log in again unless the password is changed.
+		async ProtocolSessionClose(id) {
+			const fn = "ProtocolSessionClose";
+			const paramTypes = [["int64"]];
+			const returnTypes = [["int32"]];
+			const params = [id];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// AppPasswords returns the app passwords of the account. App passwords can be
+		// used for IMAP and SMTP submission, not for the web interfaces.
+		async AppPasswords() {
+			const fn = "AppPasswords";
+			const paramTypes = [];
+			const returnTypes = [["[]", "AppPassword"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// AppPasswordAdd adds a new app password with a random password. The password is
+		// only returned now, it cannot be retrieved later. Protocols can be "imap" and/or
+		// "smtp", empty means all. If ipNets is non-empty, authentication is only allowed
+		// from those IPs/networks.
+		async AppPasswordAdd(label, protocols, ipNets) {
+			const fn = "AppPasswordAdd";
+			const paramTypes = [["string"], ["[]", "string"], ["[]", "string"]];
+			const returnTypes = [["AppPassword"], ["string"]];
+			const params = [label, protocols, ipNets];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// AppPasswordRemove removes an app password. Sessions that authenticated with the
+		// app password are not closed, see ProtocolSessionClose.
+		async AppPasswordRemove(id) {
+			const fn = "AppPasswordRemove";
+			const paramTypes = [["int64"]];
+			const returnTypes = [];
+			const params = [id];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// TOTPStatus returns whether two-factor authentication with time-based one-time
+		// passwords is enabled for logging in to the web interfaces, whether it is
+		// required by the account or domain configuration, and the number of unused
+		// recovery codes.
+		async TOTPStatus() {
+			const fn = "TOTPStatus";
+			const paramTypes = [];
+			const returnTypes = [["bool"], ["bool"], ["int32"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// TOTPSetup starts setting up two-factor authentication. The returned secret (in
+		// base32), otpauth URI and QR code (PNG as data URL, of the URI) can be added to
+		// an authenticator app. Setup must be finished with TOTPEnable.
+		async TOTPSetup() {
+			const fn = "TOTPSetup";
+			const paramTypes = [];
+			const returnTypes = [["string"], ["string"], ["string"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// TOTPEnable finishes setup of two-factor authentication by verifying a code from
+		// the authenticator app. Recovery codes are returned, each can be used once
+		// instead of a code from the authenticator app, e.g. when the device is lost.
+		// Once enabled, the account password can no longer be used for IMAP and SMTP
+		// submission, app passwords must be used instead.
+		async TOTPEnable(code) {
+			const fn = "TOTPEnable";
+			const paramTypes = [["string"]];
+			const returnTypes = [["[]", "string"]];
+			const params = [code];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// TOTPRecoveryCodesReset generates new recovery codes, invalidating all previous
+		// recovery codes.
+		async TOTPRecoveryCodesReset() {
+			const fn = "TOTPRecoveryCodesReset";
+			const paramTypes = [];
+			const returnTypes = [["[]", "string"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// TOTPDisable disables two-factor authentication, after verifying a code from the
+		// authenticator app or a recovery code. Not allowed if two-factor authentication
+		// is required by the configuration.
+		async TOTPDisable(code) {
+			const fn = "TOTPDisable";
+			const paramTypes = [["string"]];
+			const returnTypes = [];
+			const params = [code];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// Passkeys returns the passkeys of the account, for logging in to the account and
+		// webmail web interfaces without password.
+		async Passkeys() {
+			const fn = "Passkeys";
+			const paramTypes = [];
+			const returnTypes = [["[]", "Passkey"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// PasskeyRegisterPrep returns options for registering a new passkey with
+		// navigator.credentials.create in the browser. Finish registration with
+		// PasskeyRegister.
+		async PasskeyRegisterPrep() {
+			const fn = "PasskeyRegisterPrep";
+			const paramTypes = [];
+			const returnTypes = [["PasskeyCreationOptions"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// PasskeyRegister finishes registration of a passkey started with
+		// PasskeyRegisterPrep, with the response of navigator.credentials.create. Logins
+		// with the passkey get the login address of the current session.
+		async PasskeyRegister(label, response) {
+			const fn = "PasskeyRegister";
+			const paramTypes = [["string"], ["PasskeyAttestation"]];
+			const returnTypes = [["Passkey"]];
+			const params = [label, response];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// PasskeyRemove removes a passkey. Existing sessions are not affected.
+		async PasskeyRemove(id) {
+			const fn = "PasskeyRemove";
+			const paramTypes = [["int64"]];
+			const returnTypes = [];
+			const params = [id];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// PushSubscriptions returns the Web Push subscriptions of webmail on devices, for
+		// notifications about new messages.
+		async PushSubscriptions() {
+			const fn = "PushSubscriptions";
+			const paramTypes = [];
+			const returnTypes = [["[]", "PushSubscription"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// PushSubscriptionRemove removes a Web Push subscription. The device no longer
+		// gets notifications.
+		async PushSubscriptionRemove(id) {
+			const fn = "PushSubscriptionRemove";
+			const paramTypes = [["int64"]];
+			const returnTypes = [];
+			const params = [id];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// PushSubscriptionTest sends a test notification to a Web Push subscription.
+		async PushSubscriptionTest(id) {
+			const fn = "PushSubscriptionTest";
+			const paramTypes = [["int64"]];
+			const returnTypes = [];
+			const params = [id];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// RemoteContentSenders returns the senders for which external resources in HTML
+		// messages are loaded by default in webmail. Senders are email addresses, or
+		// domains as "@domain".
+		async RemoteContentSenders() {
+			const fn = "RemoteContentSenders";
+			const paramTypes = [];
+			const returnTypes = [["[]", "string"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// RemoteContentSenderAdd adds a sender, an email address or "@domain", for which
+		// external resources in HTML messages are loaded by default in webmail.
+		async RemoteContentSenderAdd(sender) {
+			const fn = "RemoteContentSenderAdd";
+			const paramTypes = [["string"]];
+			const returnTypes = [];
+			const params = [sender];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// RemoteContentSenderRemove removes a sender from the senders for which
+		// external resources are loaded by default.
+		async RemoteContentSenderRemove(sender) {
+			const fn = "RemoteContentSenderRemove";
+			const paramTypes = [["string"]];
+			const returnTypes = [];
+			const params = [sender];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// SenderList returns the allow/deny sender lists of the account, evaluated
+		// during incoming delivery.
+		async SenderList() {
+			const fn = "SenderList";
+			const paramTypes = [];
+			const returnTypes = [["[]", "SenderListEntry"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// SenderListSet adds a sender, an email address or "@domain", to the sender
+		// lists with action "allow", "reject" or "junk", replacing the action for a
+		// sender already present.
+		async SenderListSet(sender, action) {
+			const fn = "SenderListSet";
+			const paramTypes = [["string"], ["SenderAction"]];
+			const returnTypes = [["SenderListEntry"]];
+			const params = [sender, action];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// SenderListRemove removes an entry from the sender lists.
+		async SenderListRemove(id) {
+			const fn = "SenderListRemove";
+			const paramTypes = [["int64"]];
+			const returnTypes = [];
+			const params = [id];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// AliasesOwned returns the addresses of aliases created by the account itself,
+		// and the maximum number of aliases the account can create.
+		async AliasesOwned() {
+			const fn = "AliasesOwned";
+			const paramTypes = [];
+			const returnTypes = [["[]", "string"], ["int32"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// AliasCreate creates a new alias in a domain of one of the addresses of the
+		// account, with member as only member. Member must be an address of the account.
+		// The number of aliases an account can create is limited by MaxAliases in its
+		// configuration.
+		async AliasCreate(localpart, domain, member, allowMsgFrom) {
+			const fn = "AliasCreate";
+			const paramTypes = [["string"], ["string"], ["string"], ["bool"]];
+			const returnTypes = [];
+			const params = [localpart, domain, member, allowMsgFrom];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// AliasDelete removes an alias created by the account.
+		async AliasDelete(address) {
+			const fn = "AliasDelete";
+			const paramTypes = [["string"]];
+			const returnTypes = [];
+			const params = [address];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// Identities returns the sending identities of the account, with settings per
+		// address used by webmail when composing messages.
+		async Identities() {
+			const fn = "Identities";
+			const paramTypes = [];
+			const returnTypes = [["[]", "Identity"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// IdentitySave stores the settings for sending from an address, replacing any
+		// existing identity for the address. The address must be an address of the
+		// account, or an alias that allows sending with its address.
+		async IdentitySave(identity) {
+			const fn = "IdentitySave";
+			const paramTypes = [["Identity"]];
+			const returnTypes = [["Identity"]];
+			const params = [identity];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// IdentityRemove removes an identity.
+		async IdentityRemove(id) {
+			const fn = "IdentityRemove";
+			const paramTypes = [["int64"]];
+			const returnTypes = [];
+			const params = [id];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// Contacts returns the contacts in the address book of the account, sorted by
+		// name. Includes contacts harvested from recipients of sent messages.
+		async Contacts() {
+			const fn = "Contacts";
+			const paramTypes = [];
+			const returnTypes = [["[]", "Contact"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// ContactSave adds a new contact if ID is zero, or updates an existing contact.
+		// The saved contact is returned.
+		async ContactSave(contact) {
+			const fn = "ContactSave";
+			const paramTypes = [["Contact"]];
+			const returnTypes = [["Contact"]];
+			const params = [contact];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// ContactRemove removes a contact.
+		async ContactRemove(id) {
+			const fn = "ContactRemove";
+			const paramTypes = [["int64"]];
+			const returnTypes = [];
+			const params = [id];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// ContactsImport adds contacts from one or more vCards, e.g. exported from another
+		// address book. Existing contacts with the same UID are updated. The number of
+		// added/updated contacts is returned.
+		async ContactsImport(vcards) {
+			const fn = "ContactsImport";
+			const paramTypes = [["string"]];
+			const returnTypes = [["int32"]];
+			const params = [vcards];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// ContactsExport returns all contacts as vCards.
+		async ContactsExport() {
+			const fn = "ContactsExport";
+			const paramTypes = [];
+			const returnTypes = [["string"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
 	}
 	api.Client = Client;
 	api.defaultBaseURL = (function () {
@@ -868,14 +1454,76 @@ var api;
 	};
 })(api || (api = {}));
 // Javascript is generated from typescript, do not modify generated javascript because changes will be overwritten.
+// Conversion between base64url strings in the API and binary values for WebAuthn.
+const base64urlDecode = (s) => Uint8Array.from(atob(s.replace(/-/g, '+').replace(/_/g, '/')), c => c.charCodeAt(0));
+const base64urlEncode = (buf) => btoa(String.fromCharCode(...new Uint8Array(buf))).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+// passkeyLogin asks the browser for a passkey and logs in with it, returning the
+// CSRF token.
+const passkeyLogin = async () => {
+	const opts = await client.PasskeyLoginPrep();
+	const cred = await window.navigator.credentials.get({
+		publicKey: {
+			challenge: base64urlDecode(opts.Challenge),
+			rpId: opts.RPID,
+			timeout: opts.Timeout,
+			userVerification: 'required',
+		},
+	});
+	if (!cred) {
+		throw new Error('no passkey selected');
+	}
+	const resp = cred.response;
+	// The login token cookie is short-lived, so only request it now.
+	const loginToken = await client.LoginPrep();
+	return await client.PasskeyLogin(loginToken, {
+		CredentialID: cred.id,
+		ClientDataJSON: base64urlEncode(resp.clientDataJSON),
+		AuthenticatorData: base64urlEncode(resp.authenticatorData),
+		Signature: base64urlEncode(resp.signature),
+		UserHandle: resp.userHandle ? base64urlEncode(resp.userHandle) : '',
+	});
+};
+// passkeyCreate asks the browser to create a new passkey for registration.
+const passkeyCreate = async (opts) => {
+	const cred = await window.navigator.credentials.create({
+		publicKey: {
+			challenge: base64urlDecode(opts.Challenge),
+			rp: { id: opts.RPID, name: opts.RPName },
+			user: { id: base64urlDecode(opts.UserID), name: opts.UserName, displayName: opts.UserDisplayName },
+			pubKeyCredParams: (opts.Algorithms || []).map(alg => ({ type: 'public-key', alg: alg })),
+			excludeCredentials: (opts.ExcludeCredentialIDs || []).map(id => ({ type: 'public-key', id: base64urlDecode(id) })),
+			authenticatorSelection: { residentKey: 'required', userVerification: 'required' },
+			attestation: 'none',
+			timeout: opts.Timeout,
+		},
+	});
+	if (!cred) {
+		throw new Error('no passkey created');
+	}
+	const resp = cred.response;
+	return {
+		ClientDataJSON: base64urlEncode(resp.clientDataJSON),
+		AttestationObject: base64urlEncode(resp.attestationObject),
+	};
+};
 const login = async (reason) => {
-	return new Promise((resolve, _) => {
+	// Single sign-on is optional, don't prevent a login if we cannot find out.
+	let oidcEnabled = false;
+	try {
+		oidcEnabled = await client.OIDCEnabled();
+	}
+	catch (err) {
+		console.log('checking single sign-on', err);
+	}
+	return new Promise((resolve, _reject) => {
 		const origFocus = document.activeElement;
 		let reasonElem;
 		let fieldset;
 		let autosize;
 		let username;
 		let password;
+		let totpBox;
+		let totpCode;
 		const root = dom.div(style({ position: 'absolute', top: 0, right: 0, bottom: 0, left: 0, backgroundColor: '#eee', display: 'flex', alignItems: 'center', justifyContent: 'center', zIndex: '1', animation: 'fadein .15s ease-in' }), dom.div(reasonElem = reason ? dom.div(style({ marginBottom: '2ex', textAlign: 'center' }), reason) : dom.div(), dom.div(style({ backgroundColor: 'white', borderRadius: '.25em', padding: '1em', boxShadow: '0 0 20px rgba(0, 0, 0, 0.1)', border: '1px solid #ddd', maxWidth: '95vw', overflowX: 'auto', maxHeight: '95vh', overflowY: 'auto', marginBottom: '20vh' }), dom.form(async function submit(e) {
 			e.preventDefault();
 			e.stopPropagation();
@@ -883,7 +1531,7 @@ const login = async (reason) => {
 			try {
 				fieldset.disabled = true;
 				const loginToken = await client.LoginPrep();
-				const token = await client.Login(loginToken, username.value, password.value);
+				const token = await client.Login(loginToken, username.value, password.value, totpCode.value);
 				try {
 					window.localStorage.setItem('webaccountaddress', username.value);
 					window.localStorage.setItem('webaccountcsrftoken', token);
@@ -898,17 +1546,96 @@ const login = async (reason) => {
 				resolve(token);
 			}
 			catch (err) {
+				if (err.code === 'user:totpRequired') {
+					fieldset.disabled = false;
+					totpBox.style.display = '';
+					totpCode.required = true;
+					totpCode.focus();
+					return;
+				}
 				console.log('login error', err);
 				window.alert('Error: ' + errmsg(err));
 			}
 			finally {
 				fieldset.disabled = false;
 			}
-		}, fieldset = dom.fieldset(dom.h1('Account'), dom.label(style({ display: 'block', marginBottom: '2ex' }), dom.div('Email address', style({ marginBottom: '.5ex' })), autosize = dom.span(dom._class('autosize'), username = dom.input(attr.required(''), attr.placeholder('jane@example.org'), function change() { autosize.dataset.value = username.value; }, function input() { autosize.dataset.value = username.value; }))), dom.label(style({ display: 'block', marginBottom: '2ex' }), dom.div('Password', style({ marginBottom: '.5ex' })), password = dom.input(attr.type('password'), attr.required(''))), dom.div(style({ textAlign: 'center' }), dom.submitbutton('Login')))))));
+		}, fieldset = dom.fieldset(dom.h1(_('Account')), dom.label(style({ display: 'block', marginBottom: '2ex' }), dom.div(_('Email address'), style({ marginBottom: '.5ex' })), autosize = dom.span(dom._class('autosize'), username = dom.input(attr.required(''), attr.placeholder('jane@example.org'), function change() { autosize.dataset.value = username.value; }, function input() { autosize.dataset.value = username.value; }))), dom.label(style({ display: 'block', marginBottom: '2ex' }), dom.div(_('Password'), style({ marginBottom: '.5ex' })), password = dom.input(attr.type('password'), attr.required(''))), totpBox = dom.label(style({ display: 'none', marginBottom: '2ex' }), dom.div(_('Two-factor authentication code'), style({ marginBottom: '.5ex' })), totpCode = dom.input(attr.autocomplete('one-time-code'))), dom.div(style({ textAlign: 'center' }), dom.submitbutton(_('Login')), window.PublicKeyCredential ? [
+			' ',
+			dom.clickbutton(_('Login with passkey'), async function click() {
+				reasonElem.remove();
+				try {
+					fieldset.disabled = true;
+					const token = await passkeyLogin();
+					try {
+						window.localStorage.setItem('webaccountcsrftoken', token);
+					}
+					catch (err) {
+						console.log('saving csrf token in localStorage', err);
+					}
+					root.remove();
+					if (origFocus && origFocus instanceof HTMLElement && origFocus.parentNode) {
+						origFocus.focus();
+					}
+					resolve(token);
+				}
+				catch (err) {
+					console.log('passkey login error', err);
+					window.alert('Error: ' + errmsg(err));
+				}
+				finally {
+					fieldset.disabled = false;
+				}
+			}),
+		] : [], oidcEnabled ? [
+			' ',
+			dom.clickbutton(_('Login with single sign-on'), async function click() {
+				try {
+					fieldset.disabled = true;
+					window.location.href = await client.OIDCLoginPrep();
+				}
+				catch (err) {
+					console.log('single sign-on error', err);
+					window.alert('Error: ' + errmsg(err));
+					fieldset.disabled = false;
+				}
+			}),
+		] : []))))));
 		document.body.appendChild(root);
 		username.focus();
 	});
 };
+// oidcLoginFinish finishes a single sign-on login when the identity provider sent
+// the browser back with an authorization code.
+const oidcLoginFinish = async () => {
+	const params = new URLSearchParams(window.location.search);
+	const code = params.get('code');
+	const state = params.get('state');
+	const error = params.get('error');
+	if (!state || (!code && !error)) {
+		return;
+	}
+	// Remove the parameters, they can only be used once.
+	window.history.replaceState(null, '', window.location.pathname + window.location.hash);
+	if (error) {
+		window.alert('Error: single sign-on failed: ' + (params.get('error_description') || error));
+		return;
+	}
+	try {
+		const loginToken = await client.LoginPrep();
+		const token = await client.OIDCLogin(loginToken, code || '', state);
+		try {
+			window.localStorage.setItem('webaccountcsrftoken', token);
+		}
+		catch (err) {
+			console.log('saving csrf token in localStorage', err);
+		}
+		client.authState.token = token;
+	}
+	catch (err) {
+		console.log('single sign-on login error', err);
+		window.alert('Error: ' + errmsg(err));
+	}
+};
 // Popup shows kids in a centered div with white background on top of a
 // transparent overlay on top of the window. Clicking the overlay or hitting
 // Escape closes the popup. Scrollbars are automatically added to the div with
@@ -984,7 +1711,7 @@ const crumbs = (...l) => {
 	document.title = l.map(e => crumbtext(e)).join(' - ');
 	const crumblink = (e) => typeof e === 'string' ? prewrap(e) : dom.a(e.text, attr.href(e.path));
 	return [
-		dom.div(style({ float: 'right' }), localStorageGet('webaccountaddress') || '(unknown)', ' ', dom.clickbutton('Logout', attr.title('Logout, invalidating this session.'), async function click(e) {
+		dom.div(style({ float: 'right' }), localStorageGet('webaccountaddress') || '(unknown)', ' ', dom.clickbutton(_('Logout'), attr.title(_('Logout, invalidating this session.')), async function click(e) {
 			const b = e.target;
 			try {
 				b.disabled = true;
@@ -1084,6 +1811,28 @@ const formatQuotaSize = (v) => {
 };
 const index = async () => {
 	const [acc, storageUsed, storageLimit, suppressions] = await client.Account();
+	const sessions = await client.ProtocolSessions() || [];
+	const appPasswords = await client.AppPasswords() || [];
+	const [totpEnabled, totpRequired, totpRecoveryCodesLeft] = await client.TOTPStatus();
+	const [passwordChangeRequired, passwordMinLength, passwordMinEntropy, passwordBreachCheck] = await client.PasswordStatus();
+	const passkeys = await client.Passkeys() || [];
+	const pushSubscriptions = await client.PushSubscriptions() || [];
+	const remoteContentSenders = await client.RemoteContentSenders() || [];
+	const senderList = await client.SenderList() || [];
+	const [ownedAliasesList, maxAliases] = await client.AliasesOwned();
+	const ownedAliases = ownedAliasesList || [];
+	const identities = await client.Identities() || [];
+	const quarantined = await client.Quarantined() || [];
+	const language = await client.Language();
+	// The language configured for the account, e.g. in another browser, takes
+	// precedence.
+	if (language && language !== i18nPreference()) {
+		i18nPreferenceSet(language);
+		if (language !== i18nLang) {
+			window.location.reload();
+			return;
+		}
+	}
 	let fullNameForm;
 	let fullNameFieldset;
 	let fullName;
@@ -1092,11 +1841,26 @@ const index = async () => {
 	let password1;
 	let password2;
 	let passwordHint;
+	let languageFieldset;
+	let languageSelect;
+	let remoteContentSender;
+	let senderListSender;
+	let senderListAction;
+	let aliasLocalpart;
+	let aliasDomain;
+	let aliasMember;
+	let aliasAllowMsgFrom;
+	let identityAddress;
+	let identityFullName;
+	let identityReplyTo;
+	let identitySignature;
 	let autoJunkFlagsFieldset;
 	let autoJunkFlagsEnabled;
 	let junkMailboxRegexp;
 	let neutralMailboxRegexp;
 	let notJunkMailboxRegexp;
+	let junkTrainingFieldset;
+	let junkDelayFlagTraining;
 	let rejectsFieldset;
 	let rejectsMailbox;
 	let keepRejects;
@@ -1111,6 +1875,9 @@ const index = async () => {
 	let keepRetiredMessagePeriod;
 	let keepRetiredWebhookPeriod;
 	let fromIDLoginAddressesFieldset;
+	let totpSetupBox;
+	let passkeyFieldset;
+	let passkeyLabel;
 	const second = 1000 * 1000 * 1000;
 	const minute = 60 * second;
 	const hour = 60 * minute;
@@ -1164,15 +1931,28 @@ const index = async () => {
 		}
 		return format(second, 's');
 	};
+	let deleteFieldset;
+	let deletePassword;
 	let importForm;
 	let importFieldset;
 	let mailboxFileHint;
 	let mailboxPrefixHint;
 	let importProgress;
 	let importAbortBox;
+	let migrateFieldset;
+	let migrateHost;
+	let migrateStartTLS;
+	let migrateUsername;
+	let migratePassword;
+	let migratePrefix;
 	let suppressionAddress;
 	let suppressionReason;
-	const importTrack = async (token) => {
+	let appPasswordLabel;
+	let appPasswordIMAP;
+	let appPasswordSMTP;
+	let appPasswordIPNets;
+	// For migrations from a remote IMAP server, messages added before an abort are kept.
+	const importTrack = async (token, migration) => {
 		const importConnection = dom.div('Waiting for updates...');
 		importProgress.appendChild(importConnection);
 		let countsTbody;
@@ -1183,7 +1963,7 @@ const index = async () => {
 			eventSource.addEventListener('open', function (e) {
 				console.log('eventsource open', { e });
 				dom._kids(importConnection, dom.div('Waiting for updates, connected...'));
-				dom._kids(importAbortBox, dom.clickbutton('Abort import', attr.title('If the import is not yet finished, it can be aborted and no messages will have been imported.'), async function click() {
+				dom._kids(importAbortBox, dom.clickbutton('Abort import', attr.title(migration ? 'If the migration is not yet finished, it can be aborted. Messages added so far are kept, a next migration continues where it stopped.' : 'If the import is not yet finished, it can be aborted and no messages will have been imported.'), async function click() {
 					try {
 						await client.ImportAbort(token);
 					}
@@ -1232,15 +2012,17 @@ const index = async () => {
 				dom._kids(importConnection);
 				dom._kids(importAbortBox);
 				window.sessionStorage.removeItem('ImportToken');
+				window.sessionStorage.removeItem('ImportMigration');
 				resolve(null);
 			});
 			eventSource.addEventListener('aborted', function (e) {
 				console.log('import aborted event', { e });
-				importProgress.appendChild(dom.div(dom.br(), box(red, 'Import aborted, no message imported')));
+				importProgress.appendChild(dom.div(dom.br(), box(red, migration ? 'Migration aborted, messages added so far are kept, a next migration continues where this one stopped' : 'Import aborted, no message imported')));
 				eventSource.close();
 				dom._kids(importConnection);
 				dom._kids(importAbortBox);
 				window.sessionStorage.removeItem('ImportToken');
+				window.sessionStorage.removeItem('ImportMigration');
 				reject({ message: 'Import aborted' });
 			});
 		});
@@ -1378,22 +2160,60 @@ const index = async () => {
 		body.setAttribute('rows', '' + Math.min(40, (body.value.split('\n').length + 1)));
 		onchange();
 	};
-	dom._kids(page, crumbs('Mox Account'), dom.p('NOTE: Not all account settings can be configured through these pages yet. See the configuration file for more options.'), dom.div('Default domain: ', acc.DNSDomain.ASCII ? domainString(acc.DNSDomain) : '(none)'), dom.br(), fullNameForm = dom.form(fullNameFieldset = dom.fieldset(dom.label(style({ display: 'inline-block' }), 'Full name', dom.br(), fullName = dom.input(attr.value(acc.FullName), attr.title('Name to use in From header when composing messages. Can be overridden per configured address.'))), ' ', dom.submitbutton('Save')), async function submit(e) {
+	dom._kids(page, crumbs('Mox Account'), dom.p('NOTE: Not all account settings can be configured through these pages yet. See the configuration file for more options.'), passwordChangeRequired ? dom.p(box(red, 'An administrator requires you to change your password, see below. Until then, your password is only accepted for logging in to this account web interface, not for webmail, IMAP and SMTP submission. App passwords keep working.')) : [], dom.div('Default domain: ', acc.DNSDomain.ASCII ? domainString(acc.DNSDomain) : '(none)'), dom.br(), fullNameForm = dom.form(fullNameFieldset = dom.fieldset(dom.label(style({ display: 'inline-block' }), 'Full name', dom.br(), fullName = dom.input(attr.value(acc.FullName), attr.title('Name to use in From header when composing messages. Can be overridden per configured address.'))), ' ', dom.submitbutton('Save')), async function submit(e) {
 		e.preventDefault();
 		await check(fullNameFieldset, client.AccountSaveFullName(fullName.value));
 		fullName.setAttribute('value', fullName.value);
 		fullNameForm.reset();
-	}), dom.br(), dom.h2('Addresses'), dom.ul(Object.entries(acc.Destinations || {}).length === 0 ? dom.li('(None, login disabled)') : [], Object.entries(acc.Destinations || {}).sort().map(t => dom.li(dom.a(prewrap(t[0]), attr.href('#destinations/' + encodeURIComponent(t[0]))), t[0].startsWith('@') ? ' (catchall)' : []))), dom.br(), dom.h2('Aliases/lists'), dom.table(dom.thead(dom.tr(dom.th('Alias address', attr.title('Messages sent to this address will be delivered to all members of the alias/list.')), dom.th('Subscription address', attr.title('Address subscribed to the alias/list.')), dom.th('Allowed senders', attr.title('Whether only members can send through the alias/list, or anyone.')), dom.th('Send as alias address', attr.title('If enabled, messages can be sent with the alias address in the message "From" header.')), dom.th())), (acc.Aliases || []).length === 0 ? dom.tr(dom.td(attr.colspan('5'), 'None')) : [], (acc.Aliases || []).sort((a, b) => a.Alias.LocalpartStr < b.Alias.LocalpartStr ? -1 : (domainName(a.Alias.Domain) < domainName(b.Alias.Domain) ? -1 : 1)).map(a => dom.tr(dom.td(prewrap(a.Alias.LocalpartStr, '@', domainName(a.Alias.Domain))), dom.td(prewrap(a.SubscriptionAddress)), dom.td(a.Alias.PostPublic ? 'Anyone' : 'Members only'), dom.td(a.Alias.AllowMsgFrom ? 'Yes' : 'No'), dom.td((a.MemberAddresses || []).length === 0 ? [] :
+	}), dom.br(), dom.h2(_('Addresses')), dom.ul(Object.entries(acc.Destinations || {}).length === 0 ? dom.li('(None, login disabled)') : [], Object.entries(acc.Destinations || {}).sort().map(t => dom.li(dom.a(prewrap(t[0]), attr.href('#destinations/' + encodeURIComponent(t[0]))), t[0].startsWith('@') ? ' (catchall)' : []))), dom.br(), dom.h2(_('Email clients')), dom.p('Settings and setup instructions for email, calendar and contacts applications, such as Thunderbird, Outlook, iOS and macOS Mail, Thunderbird for Android/K-9 Mail and FairEmail.'), dom.ul(Object.keys(acc.Destinations || {}).filter(addr => !addr.startsWith('@')).sort().map(addr => dom.li(dom.a(prewrap(addr), attr.href('#clientsettings/' + encodeURIComponent(addr)))))), dom.br(), dom.h2(_('Contacts')), dom.p('Your address book, used for completing recipient addresses in webmail, and synchronized with phones and desktop clients through CardDAV. Recipients of messages you send are added automatically. ', dom.a(attr.href('#contacts'), 'Manage contacts'), '.'), dom.br(), dom.h2(_('Aliases/lists')), dom.table(dom.thead(dom.tr(dom.th('Alias address', attr.title('Messages sent to this address will be delivered to all members of the alias/list.')), dom.th('Subscription address', attr.title('Address subscribed to the alias/list.')), dom.th('Allowed senders', attr.title('Whether only members can send through the alias/list, or anyone.')), dom.th('Send as alias address', attr.title('If enabled, messages can be sent with the alias address in the message "From" header.')), dom.th())), (acc.Aliases || []).length === 0 ? dom.tr(dom.td(attr.colspan('5'), 'None')) : [], (acc.Aliases || []).sort((a, b) => a.Alias.LocalpartStr < b.Alias.LocalpartStr ? -1 : (domainName(a.Alias.Domain) < domainName(b.Alias.Domain) ? -1 : 1)).map(a => dom.tr(dom.td(prewrap(a.Alias.LocalpartStr, '@', domainName(a.Alias.Domain))), dom.td(prewrap(a.SubscriptionAddress)), dom.td(a.Alias.PostPublic ? 'Anyone' : 'Members only'), dom.td(a.Alias.AllowMsgFrom ? 'Yes' : 'No'), dom.td((a.MemberAddresses || []).length === 0 ? [] :
 		dom.clickbutton('Show members', function click() {
 			popup(dom.h1('Members of alias ', prewrap(a.Alias.LocalpartStr, '@', domainName(a.Alias.Domain))), dom.ul((a.MemberAddresses || []).map(addr => dom.li(prewrap(addr)))));
-		}))))), dom.br(), dom.h2('Change password'), passwordForm = dom.form(passwordFieldset = dom.fieldset(dom.label(style({ display: 'inline-block' }), 'New password', dom.br(), password1 = dom.input(attr.type('password'), attr.autocomplete('new-password'), attr.required(''), function focus() {
+		}), ' ', !ownedAliases.includes(a.Alias.LocalpartStr + '@' + domainName(a.Alias.Domain)) ? [] :
+		dom.clickbutton('Remove', async function click(e) {
+			if (!window.confirm('Are you sure you want to remove this alias?')) {
+				return;
+			}
+			await check(e.target, client.AliasDelete(a.Alias.LocalpartStr + '@' + domainName(a.Alias.Domain)));
+			window.location.reload(); // todo: reload less
+		}))))), dom.br(), maxAliases <= 0 ? [] : [
+			dom.h2(_('Create alias')),
+			dom.p('Create an alias address in one of your domains, delivering to one of your addresses. You can remove aliases you created. You have created ', '' + ownedAliases.length, ' of at most ', '' + maxAliases, ' aliases.'),
+			dom.form(async function submit(e) {
+				e.preventDefault();
+				e.stopPropagation();
+				await check(e.target, client.AliasCreate(aliasLocalpart.value, aliasDomain.value, aliasMember.value, aliasAllowMsgFrom.checked));
+				window.location.reload(); // todo: reload less
+			}, dom.fieldset(ownedAliases.length >= maxAliases ? attr.disabled('') : [], dom.label(style({ display: 'inline-block' }), 'Localpart', dom.br(), aliasLocalpart = dom.input(attr.required(''))), '@', dom.label(style({ display: 'inline-block' }), 'Domain', dom.br(), aliasDomain = dom.select(attr.required(''), [...new Set(Object.keys(acc.Destinations || {}).map(s => s.substring(s.lastIndexOf('@') + 1)))].sort().map(d => dom.option(d)))), ' ', dom.label(style({ display: 'inline-block' }), 'Deliver to', dom.br(), aliasMember = dom.select(attr.required(''), Object.keys(acc.Destinations || {}).filter(s => !s.startsWith('@')).sort().map(s => dom.option(s)))), ' ', dom.label(style({ display: 'inline-block' }), aliasAllowMsgFrom = dom.input(attr.type('checkbox'), attr.checked('')), ' Allow sending as alias address'), ' ', dom.submitbutton('Create alias'))),
+			dom.br(),
+		], dom.h2(_('Sending identities')), dom.p('Settings for composing messages in webmail with one of your addresses as From address: a display name instead of your full name, a Reply-To address, and a signature instead of the signature in the webmail settings.'), dom.form(attr.id('identitySave'), async function submit(e) {
+		e.preventDefault();
+		e.stopPropagation();
+		const identity = {
+			ID: 0,
+			Address: identityAddress.value,
+			FullName: identityFullName.value,
+			ReplyTo: identityReplyTo.value,
+			Signature: identitySignature.value,
+		};
+		await check(e.target, client.IdentitySave(identity));
+		window.location.reload(); // todo: reload less
+	}), dom.table(dom.thead(dom.tr(dom.th('Address'), dom.th('Name'), dom.th('Reply-To'), dom.th('Signature'), dom.th('Action'))), dom.tbody(identities.length === 0 ? dom.tr(dom.td(attr.colspan('5'), '(None)')) : [], identities.map(ident => dom.tr(dom.td(prewrap(ident.Address)), dom.td(ident.FullName), dom.td(ident.ReplyTo), dom.td(style({ whiteSpace: 'pre-wrap' }), ident.Signature), dom.td(dom.clickbutton('Edit', function click() {
+		identityAddress.value = ident.Address;
+		identityFullName.value = ident.FullName;
+		identityReplyTo.value = ident.ReplyTo;
+		identitySignature.value = ident.Signature;
+		identityFullName.focus();
+	}), ' ', dom.clickbutton('Remove', async function click(e) {
+		await check(e.target, client.IdentityRemove(ident.ID));
+		window.location.reload(); // todo: reload less
+	}))))), dom.tfoot(dom.tr(dom.td(identityAddress = dom.select(attr.required(''), attr.form('identitySave'), [...Object.keys(acc.Destinations || {}).filter(s => !s.startsWith('@')), ...(acc.Aliases || []).filter(a => a.Alias.AllowMsgFrom).map(a => a.Alias.LocalpartStr + '@' + domainName(a.Alias.Domain))].sort().map(s => dom.option(s)))), dom.td(identityFullName = dom.input(attr.placeholder(acc.FullName), attr.form('identitySave'))), dom.td(identityReplyTo = dom.input(attr.placeholder('Optional'), attr.form('identitySave'))), dom.td(identitySignature = dom.textarea(attr.rows('3'), attr.form('identitySave'))), dom.td(dom.submitbutton('Save', attr.form('identitySave')))))), dom.br(), dom.h2(_('Change password')), passwordForm = dom.form(passwordFieldset = dom.fieldset(dom.label(style({ display: 'inline-block' }), 'New password', dom.br(), password1 = dom.input(attr.type('password'), attr.autocomplete('new-password'), attr.required(''), function focus() {
 		passwordHint.style.display = '';
 	})), ' ', dom.label(style({ display: 'inline-block' }), 'New password repeat', dom.br(), password2 = dom.input(attr.type('password'), attr.autocomplete('new-password'), attr.required(''))), ' ', dom.submitbutton('Change password')), passwordHint = dom.div(style({ display: 'none', marginTop: '.5ex' }), dom.clickbutton('Generate random password', function click(e) {
 		e.preventDefault();
 		let b = new Uint8Array(1);
 		let s = '';
 		const chars = 'abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789!@#$%^&*-_;:,<.>/';
-		while (s.length < 12) {
+		while (s.length < Math.max(12, passwordMinLength)) {
 			self.crypto.getRandomValues(b);
 			if (Math.ceil(b[0] / chars.length) * chars.length > 255) {
 				continue; // Prevent bias.
@@ -1404,7 +2224,7 @@ const index = async () => {
 		password2.type = 'text';
 		password1.value = s;
 		password2.value = s;
-	}), dom.div(dom._class('text'), box(yellow, 'Important: Bots will try to bruteforce your password. Connections with failed authentication attempts will be rate limited but attackers WILL find weak passwords. If your account is compromised, spammers are likely to abuse your system, spamming your address and the wider internet in your name. So please pick a random, unguessable password, preferrably at least 12 characters.'))), async function submit(e) {
+	}), dom.div(dom._class('text'), 'Requirements: at least ' + passwordMinLength + ' characters' + (passwordMinEntropy ? ', an estimated strength of at least ' + passwordMinEntropy + ' bits' : '') + (passwordBreachCheck ? ', not found in known data breaches' : '') + '.'), dom.div(dom._class('text'), box(yellow, 'Important: Bots will try to bruteforce your password. Connections with failed authentication attempts will be rate limited but attackers WILL find weak passwords. If your account is compromised, spammers are likely to abuse your system, spamming your address and the wider internet in your name. So please pick a random, unguessable password, preferrably at least 12 characters.'))), async function submit(e) {
 		e.stopPropagation();
 		e.preventDefault();
 		if (!password1.value || password1.value !== password2.value) {
@@ -1413,7 +2233,15 @@ const index = async () => {
 		}
 		await check(passwordFieldset, client.SetPassword(password1.value));
 		passwordForm.reset();
-	}), dom.br(), dom.h2('Disk usage'), dom.p('Storage used is ', dom.b(formatQuotaSize(Math.floor(storageUsed / (1024 * 1024)) * 1024 * 1024)), storageLimit > 0 ? [
+		if (passwordChangeRequired) {
+			window.location.reload();
+		}
+	}), dom.br(), dom.h2(_('Language')), dom.form(languageFieldset = dom.fieldset(dom.label(style({ display: 'inline-block' }), attr.title(_('Language of the user interface, and of messages sent to you by the mail server, such as delivery failure notifications.')), languageSelect = dom.select(dom.option(attr.value(''), _('Browser default')), i18nLanguages.map(l => dom.option(attr.value(l.Code), l.Name, l.Code === language ? attr.selected('') : [])))), ' ', dom.submitbutton(_('Save'))), async function submit(e) {
+		e.preventDefault();
+		await check(languageFieldset, client.LanguageSave(languageSelect.value));
+		i18nPreferenceSet(languageSelect.value);
+		window.location.reload();
+	}), dom.br(), dom.h2(_('Disk usage')), dom.p('Storage used is ', dom.b(formatQuotaSize(Math.floor(storageUsed / (1024 * 1024)) * 1024 * 1024)), storageLimit > 0 ? [
 		dom.b('/', formatQuotaSize(storageLimit)),
 		' (',
 		'' + Math.floor(100 * storageUsed / storageLimit),
@@ -1422,11 +2250,26 @@ const index = async () => {
 		e.preventDefault();
 		e.stopPropagation();
 		await check(autoJunkFlagsFieldset, client.AutomaticJunkFlagsSave(autoJunkFlagsEnabled.checked, junkMailboxRegexp.value, neutralMailboxRegexp.value, notJunkMailboxRegexp.value));
-	}, autoJunkFlagsFieldset = dom.fieldset(dom.div(style({ display: 'flex', gap: '1em' }), dom.label('Enabled', attr.title("If enabled, junk/nonjunk flags will be set automatically if they match a regular expression below. When two of the three mailbox regular expressions are set, the remaining one will match all unmatched messages. Messages are matched in order 'junk', 'neutral', 'not junk', and the search stops on the first match. Mailboxes are lowercased before matching."), dom.div(autoJunkFlagsEnabled = dom.input(attr.type('checkbox'), acc.AutomaticJunkFlags.Enabled ? attr.checked('') : []))), dom.label('Junk mailbox regexp', dom.div(junkMailboxRegexp = dom.input(attr.value(acc.AutomaticJunkFlags.JunkMailboxRegexp)))), dom.label('Neutral mailbox regexp', dom.div(neutralMailboxRegexp = dom.input(attr.value(acc.AutomaticJunkFlags.NeutralMailboxRegexp)))), dom.label('Not Junk mailbox regexp', dom.div(notJunkMailboxRegexp = dom.input(attr.value(acc.AutomaticJunkFlags.NotJunkMailboxRegexp)))), dom.div(dom.span('\u00a0'), dom.div(dom.submitbutton('Save')))))), dom.br(), dom.h2('Rejects'), dom.form(async function submit(e) {
+	}, autoJunkFlagsFieldset = dom.fieldset(dom.div(style({ display: 'flex', gap: '1em' }), dom.label('Enabled', attr.title("If enabled, junk/nonjunk flags will be set automatically if they match a regular expression below. When two of the three mailbox regular expressions are set, the remaining one will match all unmatched messages. Messages are matched in order 'junk', 'neutral', 'not junk', and the search stops on the first match. Mailboxes are lowercased before matching."), dom.div(autoJunkFlagsEnabled = dom.input(attr.type('checkbox'), acc.AutomaticJunkFlags.Enabled ? attr.checked('') : []))), dom.label('Junk mailbox regexp', dom.div(junkMailboxRegexp = dom.input(attr.value(acc.AutomaticJunkFlags.JunkMailboxRegexp)))), dom.label('Neutral mailbox regexp', dom.div(neutralMailboxRegexp = dom.input(attr.value(acc.AutomaticJunkFlags.NeutralMailboxRegexp)))), dom.label('Not Junk mailbox regexp', dom.div(notJunkMailboxRegexp = dom.input(attr.value(acc.AutomaticJunkFlags.NotJunkMailboxRegexp)))), dom.div(dom.span('\u00a0'), dom.div(dom.submitbutton('Save')))))), dom.br(), !acc.JunkFilter ? [] : [
+		dom.h2('Junk filter training', attr.title('The junk filter is trained with messages that have the junk or nonjunk flag set. When the flags of a message change, e.g. because an email client moves it to or from the Junk mailbox, or sets the $Junk or $NotJunk flag, the junk filter is retrained.')),
+		dom.form(async function submit(e) {
+			e.preventDefault();
+			e.stopPropagation();
+			await check(junkTrainingFieldset, client.JunkFilterTrainingSave(junkDelayFlagTraining.checked));
+		}, junkTrainingFieldset = dom.fieldset(dom.label(junkDelayFlagTraining = dom.input(attr.type('checkbox'), acc.JunkFilter.DelayFlagTraining ? attr.checked('') : []), ' Delay retraining after flag changes', attr.title('If checked, the junk filter is not retrained immediately when junk/nonjunk flags change, but once a day for all messages with changed flags.')), ' ', dom.submitbutton('Save'), ' ', dom.clickbutton('Retrain now', attr.title('Retrain the junk filter with all messages whose junk/nonjunk flags changed since they were last trained.'), async function click(e) {
+			const n = await check(e.target, client.JunkFilterRetrainBacklog());
+			window.alert('Retrained ' + n + ' message(s).');
+		}))),
+		dom.br(),
+	], quarantined.length === 0 ? [] : [
+		dom.h2('Quarantine'),
+		dom.p('' + quarantined.length + ' incoming message(s) held in quarantine because they look suspicious. ', dom.a(attr.href('#quarantine'), 'Review quarantined messages'), '.'),
+		dom.br(),
+	], dom.h2(_('Rejects')), dom.form(async function submit(e) {
 		e.preventDefault();
 		e.stopPropagation();
 		await check(rejectsFieldset, client.RejectsSave(rejectsMailbox.value, keepRejects.checked));
-	}, rejectsFieldset = dom.fieldset(dom.div(style({ display: 'flex', gap: '1em' }), dom.label('Mailbox', attr.title("Mail that looks like spam will be rejected, but a copy can be stored temporarily in a mailbox, e.g. Rejects. If mail isn't coming in when you expect, you can look there. The mail still isn't accepted, so the remote mail server may retry (hopefully, if legitimate), or give up (hopefully, if indeed a spammer). Messages are automatically removed from this mailbox, so do not set it to a mailbox that has messages you want to keep."), dom.div(rejectsMailbox = dom.input(attr.value(acc.RejectsMailbox)))), dom.label("No cleanup", attr.title("Don't automatically delete mail in the RejectsMailbox listed above. This can be useful, e.g. for future spam training. It can also cause storage to fill up."), dom.div(keepRejects = dom.input(attr.type('checkbox'), acc.KeepRejects ? attr.checked('') : []))), dom.div(dom.span('\u00a0'), dom.div(dom.submitbutton('Save')))))), dom.br(), dom.h2('Webhooks'), dom.h3('Outgoing', attr.title('Webhooks for outgoing messages are called for each attempt to deliver a message in the outgoing queue, e.g. when the queue has delivered a message to the next hop, when a single attempt failed with a temporary error, when delivery permanently failed, or when DSN (delivery status notification) messages were received about a previously sent message.')), dom.form(async function submit(e) {
+	}, rejectsFieldset = dom.fieldset(dom.div(style({ display: 'flex', gap: '1em' }), dom.label('Mailbox', attr.title("Mail that looks like spam will be rejected, but a copy can be stored temporarily in a mailbox, e.g. Rejects. If mail isn't coming in when you expect, you can look there. The mail still isn't accepted, so the remote mail server may retry (hopefully, if legitimate), or give up (hopefully, if indeed a spammer). Messages are automatically removed from this mailbox, so do not set it to a mailbox that has messages you want to keep."), dom.div(rejectsMailbox = dom.input(attr.value(acc.RejectsMailbox)))), dom.label("No cleanup", attr.title("Don't automatically delete mail in the RejectsMailbox listed above. This can be useful, e.g. for future spam training. It can also cause storage to fill up."), dom.div(keepRejects = dom.input(attr.type('checkbox'), acc.KeepRejects ? attr.checked('') : []))), dom.div(dom.span('\u00a0'), dom.div(dom.submitbutton('Save')))))), dom.br(), dom.h2(_('Webhooks')), dom.h3('Outgoing', attr.title('Webhooks for outgoing messages are called for each attempt to deliver a message in the outgoing queue, e.g. when the queue has delivered a message to the next hop, when a single attempt failed with a temporary error, when delivery permanently failed, or when DSN (delivery status notification) messages were received about a previously sent message.')), dom.form(async function submit(e) {
 		e.preventDefault();
 		e.stopPropagation();
 		await check(outgoingWebhookFieldset, client.OutgoingWebhookSave(outgoingWebhookURL.value, outgoingWebhookAuthorization.value, [...outgoingWebhookEvents.selectedOptions].map(o => o.value)));
@@ -1478,7 +2321,7 @@ const index = async () => {
 		};
 		elem = render();
 		return elem;
-	})(), dom.br(), dom.h2('Suppression list'), dom.p('Messages queued for delivery to recipients on the suppression list will immediately fail. If delivery to a recipient fails repeatedly, it can be added to the suppression list automatically. Repeated rejected delivery attempts can have a negative influence of mail server reputation. Applications sending email can implement their own handling of delivery failure notifications, but not all do.'), dom.form(attr.id('suppressionAdd'), async function submit(e) {
+	})(), dom.br(), dom.h2(_('Suppression list')), dom.p('Messages queued for delivery to recipients on the suppression list will immediately fail. If delivery to a recipient fails repeatedly, it can be added to the suppression list automatically. Repeated rejected delivery attempts can have a negative influence of mail server reputation. Applications sending email can implement their own handling of delivery failure notifications, but not all do.'), dom.form(attr.id('suppressionAdd'), async function submit(e) {
 		e.preventDefault();
 		e.stopPropagation();
 		await check(e.target, client.SuppressionAdd(suppressionAddress.value, true, suppressionReason.value));
@@ -1486,7 +2329,99 @@ const index = async () => {
 	}), dom.table(dom.thead(dom.tr(dom.th('Address', attr.title('Address that caused this entry to be added to the list. The title (shown on hover) displays an address with a fictional simplified localpart, with lower-cased, dots removed, only first part before "+" or "-" (typicaly catchall separators). When checking if an address is on the suppression list, it is checked against this address.')), dom.th('Manual', attr.title('Whether suppression was added manually, instead of automatically based on bounces.')), dom.th('Reason'), dom.th('Since'), dom.th('Action'))), dom.tbody((suppressions || []).length === 0 ? dom.tr(dom.td(attr.colspan('5'), '(None)')) : [], (suppressions || []).map(s => dom.tr(dom.td(prewrap(s.OriginalAddress), attr.title(s.BaseAddress)), dom.td(s.Manual ? '✓' : ''), dom.td(s.Reason), dom.td(age(s.Created)), dom.td(dom.clickbutton('Remove', async function click(e) {
 		await check(e.target, client.SuppressionRemove(s.OriginalAddress));
 		window.location.reload(); // todo: reload less
-	}))))), dom.tfoot(dom.tr(dom.td(suppressionAddress = dom.input(attr.type('required'), attr.form('suppressionAdd'))), dom.td(), dom.td(suppressionReason = dom.input(style({ width: '100%' }), attr.form('suppressionAdd'))), dom.td(), dom.td(dom.submitbutton('Add suppression', attr.form('suppressionAdd')))))), dom.br(), dom.h2('Export'), dom.p('Export all messages in all mailboxes.'), dom.form(attr.target('_blank'), attr.method('POST'), attr.action('export'), dom.input(attr.type('hidden'), attr.name('csrf'), attr.value(localStorageGet('webaccountcsrftoken') || '')), dom.input(attr.type('hidden'), attr.name('mailbox'), attr.value('')), dom.input(attr.type('hidden'), attr.name('recursive'), attr.value('on')), dom.div(style({ display: 'flex', flexDirection: 'column', gap: '.5ex' }), dom.div(dom.label(dom.input(attr.type('radio'), attr.name('format'), attr.value('maildir'), attr.checked('')), ' Maildir'), ' ', dom.label(dom.input(attr.type('radio'), attr.name('format'), attr.value('mbox')), ' Mbox')), dom.div(dom.label(dom.input(attr.type('radio'), attr.name('archive'), attr.value('tar')), ' Tar'), ' ', dom.label(dom.input(attr.type('radio'), attr.name('archive'), attr.value('tgz'), attr.checked('')), ' Tgz'), ' ', dom.label(dom.input(attr.type('radio'), attr.name('archive'), attr.value('zip')), ' Zip'), ' '), dom.div(style({ marginTop: '1ex' }), dom.submitbutton('Export')))), dom.br(), dom.h2('Import'), dom.p('Import messages from a .zip or .tgz file with maildirs and/or mbox files.'), importForm = dom.form(async function submit(e) {
+	}))))), dom.tfoot(dom.tr(dom.td(suppressionAddress = dom.input(attr.type('required'), attr.form('suppressionAdd'))), dom.td(), dom.td(suppressionReason = dom.input(style({ width: '100%' }), attr.form('suppressionAdd'))), dom.td(), dom.td(dom.submitbutton('Add suppression', attr.form('suppressionAdd')))))), dom.br(), dom.h2(_('Two-factor authentication')), dom.p('With two-factor authentication, logging in to the web interfaces requires a code from an authenticator app, in addition to your password. Once enabled, your account password can no longer be used for IMAP and SMTP submission, use app passwords instead.'), totpRequired && !totpEnabled ? dom.p(box(yellow, 'Two-factor authentication is required for this account, logging in to webmail is not possible until it is set up.')) : [], totpEnabled ?
+		dom.div(dom.p('Two-factor authentication is enabled. Unused recovery codes: ' + totpRecoveryCodesLeft + '.'), dom.clickbutton('Generate new recovery codes', async function click(e) {
+			if (!window.confirm('Are you sure? Previous recovery codes will no longer work.')) {
+				return;
+			}
+			const codes = await check(e.target, client.TOTPRecoveryCodesReset());
+			window.alert('New recovery codes, each can be used once instead of a code from your authenticator app:\n\n' + (codes || []).join('\n') + '\n\nStore them in a safe place, they will not be shown again.');
+			window.location.reload(); // todo: reload less
+		}), ' ', totpRequired ? [] : dom.clickbutton('Disable', async function click(e) {
+			const code = window.prompt('Code from your authenticator app, or a recovery code:');
+			if (!code) {
+				return;
+			}
+			await check(e.target, client.TOTPDisable(code));
+			window.location.reload(); // todo: reload less
+		})) :
+		totpSetupBox = dom.div(dom.clickbutton('Set up two-factor authentication', async function click(e) {
+			const [secret, , qrcode] = await check(e.target, client.TOTPSetup());
+			let fieldset;
+			let code;
+			dom._kids(totpSetupBox, dom.p('Scan the QR code with your authenticator app, or enter the secret manually. Then enter the code shown by the app to confirm.'), dom.img(attr.src(qrcode)), dom.pre(secret), dom.form(async function submit(e) {
+				e.preventDefault();
+				e.stopPropagation();
+				const codes = await check(fieldset, client.TOTPEnable(code.value));
+				window.alert('Two-factor authentication enabled. Recovery codes, each can be used once instead of a code from your authenticator app:\n\n' + (codes || []).join('\n') + '\n\nStore them in a safe place, they will not be shown again.');
+				window.location.reload(); // todo: reload less
+			}, fieldset = dom.fieldset(dom.label('Code ', code = dom.input(attr.required(''), attr.autocomplete('one-time-code'))), ' ', dom.submitbutton('Enable'))));
+			code.focus();
+		})), dom.br(), dom.h2(_('Passkeys')), dom.p('Passkeys let you log in to the web interfaces without password, with a device or security key that verifies it is you, e.g. with a fingerprint or PIN. No two-factor authentication code is needed when logging in with a passkey. Passkeys cannot be used for IMAP and SMTP submission.'), dom.table(dom.thead(dom.tr(dom.th('Label'), dom.th('Login address'), dom.th('Created'), dom.th('Last used'), dom.th('Action'))), dom.tbody(passkeys.length === 0 ? dom.tr(dom.td(attr.colspan('5'), '(None)')) : [], passkeys.map(pk => dom.tr(dom.td(pk.Label), dom.td(pk.LoginAddress), dom.td(age(pk.Created)), dom.td(pk.LastUsed.getTime() > 0 ? age(pk.LastUsed) : 'Never'), dom.td(dom.clickbutton('Remove', async function click(e) {
+		if (!window.confirm('Are you sure you want to remove this passkey?')) {
+			return;
+		}
+		await check(e.target, client.PasskeyRemove(pk.ID));
+		window.location.reload(); // todo: reload less
+	})))))), window.PublicKeyCredential ?
+		dom.form(style({ marginTop: '1ex' }), async function submit(e) {
+			e.preventDefault();
+			e.stopPropagation();
+			await check(passkeyFieldset, (async () => {
+				const opts = await client.PasskeyRegisterPrep();
+				const response = await passkeyCreate(opts);
+				await client.PasskeyRegister(passkeyLabel.value, response);
+			})());
+			window.location.reload(); // todo: reload less
+		}, passkeyFieldset = dom.fieldset(dom.label('Label ', passkeyLabel = dom.input(attr.required(''), attr.placeholder('e.g. laptop'))), ' ', dom.submitbutton('Add passkey'))) :
+		dom.p('Your browser does not support passkeys.'), dom.br(), dom.h2(_('Push notifications')), dom.p('Devices on which notifications about new messages are enabled in webmail. Notifications are shown while webmail is not open. Enable notifications on a device in the webmail settings.'), dom.table(dom.thead(dom.tr(dom.th('Device'), dom.th('Created'), dom.th('Last notification'), dom.th('Last error'), dom.th('Action'))), dom.tbody(pushSubscriptions.length === 0 ? dom.tr(dom.td(attr.colspan('5'), '(None)')) : [], pushSubscriptions.map(ps => dom.tr(dom.td(ps.Label || '-'), dom.td(age(ps.Created)), dom.td(ps.LastPush.getTime() > 0 ? age(ps.LastPush) : 'Never'), dom.td(ps.LastError), dom.td(dom.clickbutton('Test', attr.title('Send a test notification to this device.'), async function click(e) {
+		await check(e.target, client.PushSubscriptionTest(ps.ID));
+	}), ' ', dom.clickbutton('Remove', async function click(e) {
+		if (!window.confirm('Are you sure you want to remove notifications for this device?')) {
+			return;
+		}
+		await check(e.target, client.PushSubscriptionRemove(ps.ID));
+		window.location.reload(); // todo: reload less
+	})))))), dom.br(), dom.h2(_('Remote content')), dom.p('External resources, such as images, in HTML messages from these senders are loaded by default in webmail. Loading external resources can reveal to the sender that and when you read a message. Senders are added when you choose to show a message with external resources in webmail. Add a domain as "@domain".'), dom.form(attr.id('remoteContentSenderAdd'), async function submit(e) {
+		e.preventDefault();
+		e.stopPropagation();
+		await check(e.target, client.RemoteContentSenderAdd(remoteContentSender.value));
+		window.location.reload(); // todo: reload less
+	}), dom.table(dom.thead(dom.tr(dom.th('Sender'), dom.th('Action'))), dom.tbody(remoteContentSenders.length === 0 ? dom.tr(dom.td(attr.colspan('2'), '(None)')) : [], remoteContentSenders.map(s => dom.tr(dom.td(s), dom.td(dom.clickbutton('Remove', async function click(e) {
+		await check(e.target, client.RemoteContentSenderRemove(s));
+		window.location.reload(); // todo: reload less
+	}))))), dom.tfoot(dom.tr(dom.td(remoteContentSender = dom.input(attr.required(''), attr.placeholder('e.g. news@example.com or @example.com'), attr.form('remoteContentSenderAdd'))), dom.td(dom.submitbutton('Add sender', attr.form('remoteContentSenderAdd')))))), dom.br(), dom.h2('Sender lists'), dom.p('Incoming messages from senders on the allow list skip junk filtering, if the sender address is verified with DMARC or SPF. Messages from senders on the reject list are refused, and messages from senders on the junk list are delivered to the Junk mailbox. Add a domain as "@domain", it also matches subdomains. These lists take precedence over the sender lists of the domain.'), dom.form(attr.id('senderListAdd'), async function submit(e) {
+		e.preventDefault();
+		e.stopPropagation();
+		await check(e.target, client.SenderListSet(senderListSender.value, senderListAction.value));
+		window.location.reload(); // todo: reload less
+	}), dom.table(dom.thead(dom.tr(dom.th('Sender'), dom.th('List'), dom.th('Added'), dom.th('Action'))), dom.tbody(senderList.length === 0 ? dom.tr(dom.td(attr.colspan('4'), '(None)')) : [], senderList.map(e => dom.tr(dom.td(e.Sender), dom.td(e.Action), dom.td(age(e.Created)), dom.td(dom.clickbutton('Remove', async function click(ev) {
+		await check(ev.target, client.SenderListRemove(e.ID));
+		window.location.reload(); // todo: reload less
+	}))))), dom.tfoot(dom.tr(dom.td(senderListSender = dom.input(attr.required(''), attr.placeholder('e.g. news@example.com or @example.com'), attr.form('senderListAdd'))), dom.td(senderListAction = dom.select(attr.form('senderListAdd'), dom.option('allow', attr.value(api.SenderAction.SenderAllow)), dom.option('reject', attr.value(api.SenderAction.SenderReject)), dom.option('junk', attr.value(api.SenderAction.SenderJunk)))), dom.td(), dom.td(dom.submitbutton('Add sender', attr.form('senderListAdd')))))), dom.br(), dom.h2(_('App passwords')), dom.p('App passwords are random passwords for email clients on your devices, for IMAP and SMTP submission. They cannot be used to log in to the web interface. Give each device its own app password, so it can be removed individually when a device is lost. App passwords only work with authentication mechanisms that send the password, e.g. IMAP LOGIN and SASL PLAIN, not with SCRAM or CRAM-MD5.'), dom.form(attr.id('appPasswordAdd'), async function submit(e) {
+		e.preventDefault();
+		e.stopPropagation();
+		const protocols = [appPasswordIMAP.checked ? 'imap' : '', appPasswordSMTP.checked ? 'smtp' : ''].filter(s => s);
+		const ipNets = appPasswordIPNets.value.split(/[\s,]+/).filter(s => s);
+		const [, password] = await check(e.target, client.AppPasswordAdd(appPasswordLabel.value, protocols, ipNets));
+		window.alert('New app password:\n\n' + password + '\n\nConfigure it in your email client now, it will not be shown again.');
+		window.location.reload(); // todo: reload less
+	}), dom.table(dom.thead(dom.tr(dom.th('Label'), dom.th('Protocols', attr.title('Protocols the app password can be used for. If none are selected, it can be used for all.')), dom.th('IP networks', attr.title('If set, the app password can only be used from these IPs or networks, separated by comma or space.')), dom.th('Created'), dom.th('Last used'), dom.th('Action'))), dom.tbody(appPasswords.length === 0 ? dom.tr(dom.td(attr.colspan('6'), '(None)')) : [], appPasswords.map(ap => dom.tr(dom.td(ap.Label), dom.td((ap.Protocols || []).join(', ') || 'All'), dom.td((ap.IPNets || []).join(', ') || 'Any'), dom.td(age(ap.Created)), dom.td(ap.LastUsed.getTime() > 0 ? age(ap.LastUsed) : 'Never'), dom.td(dom.clickbutton('Remove', async function click(e) {
+		if (!window.confirm('Are you sure you want to remove this app password? Active sessions are not closed, close them in the list of sessions below.')) {
+			return;
+		}
+		await check(e.target, client.AppPasswordRemove(ap.ID));
+		window.location.reload(); // todo: reload less
+	}))))), dom.tfoot(dom.tr(dom.td(appPasswordLabel = dom.input(attr.required(''), attr.placeholder('e.g. phone'), attr.form('appPasswordAdd'))), dom.td(dom.label(appPasswordIMAP = dom.input(attr.type('checkbox'), attr.form('appPasswordAdd')), ' IMAP'), ' ', dom.label(appPasswordSMTP = dom.input(attr.type('checkbox'), attr.form('appPasswordAdd')), ' SMTP')), dom.td(appPasswordIPNets = dom.input(attr.placeholder('e.g. 192.0.2.0/24'), attr.form('appPasswordAdd'))), dom.td(), dom.td(), dom.td(dom.submitbutton('Add app password', attr.form('appPasswordAdd')))))), dom.br(), dom.h2(_('Sessions')), dom.p('Active and recent IMAP and SMTP submission sessions, from email clients on your devices. If a device is lost, you can close its sessions. The email client can log in again with your password, so also change your password.'), dom.table(dom.thead(dom.tr(dom.th('Protocol'), dom.th('Login address'), dom.th('Remote IP'), dom.th('Client', attr.title('Client identification, from the IMAP ID command or the SMTP EHLO hostname.')), dom.th('Started'), dom.th('Last activity'), dom.th('Status'), dom.th('Action'))), dom.tbody(sessions.length === 0 ? dom.tr(dom.td(attr.colspan('8'), '(None)')) : [], sessions.map(s => dom.tr(dom.td(s.Protocol), dom.td(s.LoginAddress), dom.td(s.RemoteIP), dom.td(s.ClientID), dom.td(age(s.Started)), dom.td(age(s.LastActivity)), dom.td(s.Active ? 'Active' : (s.Closed ? 'Closed' : 'Ended')), dom.td(!s.Active ? [] : dom.clickbutton('Close', async function click(e) {
+		await check(e.target, client.ProtocolSessionClose(s.ID));
+		window.location.reload(); // todo: reload less
+	})))))), dom.div(style({ marginTop: '1ex' }), dom.clickbutton('Close all active sessions', async function click(e) {
+		if (!window.confirm('Are you sure you want to close all active sessions?')) {
+			return;
+		}
+		await check(e.target, client.ProtocolSessionClose(0));
+		window.location.reload(); // todo: reload less
+	})), dom.br(), dom.h2(_('Export')), dom.p('Export messages of all mailboxes, or a selection of mailboxes and messages. The archive is downloaded while it is being created.'), dom.form(attr.target('_blank'), attr.method('POST'), attr.action('export'), dom.input(attr.type('hidden'), attr.name('csrf'), attr.value(localStorageGet('webaccountcsrftoken') || '')), dom.input(attr.type('hidden'), attr.name('mailbox'), attr.value('')), dom.input(attr.type('hidden'), attr.name('recursive'), attr.value('on')), dom.div(style({ display: 'flex', flexDirection: 'column', gap: '.5ex' }), dom.div(dom.label(dom.input(attr.type('radio'), attr.name('format'), attr.value('maildir'), attr.checked('')), ' Maildir'), ' ', dom.label(dom.input(attr.type('radio'), attr.name('format'), attr.value('mbox')), ' Mbox'), ' ', dom.label(dom.input(attr.type('radio'), attr.name('format'), attr.value('eml')), ' EML')), dom.div(dom.label(dom.input(attr.type('radio'), attr.name('archive'), attr.value('tar')), ' Tar'), ' ', dom.label(dom.input(attr.type('radio'), attr.name('archive'), attr.value('tgz'), attr.checked('')), ' Tgz'), ' ', dom.label(dom.input(attr.type('radio'), attr.name('archive'), attr.value('zip')), ' Zip'), ' '), dom.div(style({ display: 'flex', gap: '1em', flexWrap: 'wrap', alignItems: 'flex-start' }), dom.label(dom.div('Only mailboxes', attr.title('Patterns for mailbox names, one per line, e.g. "Archive/20*". A pattern also matches child mailboxes. If empty, all mailboxes are exported.')), dom.textarea(attr.name('include'), attr.rows('2'))), dom.label(dom.div('Skip mailboxes', attr.title('Patterns for mailbox names not to export, one per line, e.g. "Junk". A pattern also matches child mailboxes.')), dom.textarea(attr.name('exclude'), attr.rows('2'))), dom.label(dom.div('Received after'), dom.input(attr.type('date'), attr.name('after'))), dom.label(dom.div('Received before'), dom.input(attr.type('date'), attr.name('before'))), dom.label(dom.div('With flags', attr.title('Only export messages with all these flags, space-separated, e.g. \\Seen, $Junk or custom keywords.')), dom.input(attr.name('flags'))), dom.label(dom.div('Without flags', attr.title('Only export messages without any of these flags, space-separated.')), dom.input(attr.name('notflags')))), dom.div(style({ marginTop: '1ex' }), dom.submitbutton('Export')))), dom.br(), dom.h2(_('Takeout')), dom.p('Download all data of your account in a single archive: account configuration with addresses, settings, identities, filter rules, contacts, calendar events and all mailboxes with messages in maildir format.'), dom.form(attr.target('_blank'), attr.method('POST'), attr.action('takeout'), dom.input(attr.type('hidden'), attr.name('csrf'), attr.value(localStorageGet('webaccountcsrftoken') || '')), dom.submitbutton('Download takeout')), dom.br(), dom.h2(_('Import')), dom.p('Import messages from a .zip or .tgz file with maildirs, mbox files and/or .eml files, or from an Outlook .pst file.'), importForm = dom.form(async function submit(e) {
 		e.preventDefault();
 		e.stopPropagation();
 		const request = async () => {
@@ -1537,7 +2472,7 @@ const index = async () => {
 				console.log('storing import token in session storage', { err });
 				// Ignore error, could be some browser security thing like private browsing.
 			}
-			await importTrack(result.Token);
+			await importTrack(result.Token, false);
 		}
 		catch (err) {
 			console.log({ err });
@@ -1548,15 +2483,54 @@ const index = async () => {
 		}
 	}, importFieldset = dom.fieldset(dom.div(style({ marginBottom: '1ex' }), dom.label(dom.div(style({ marginBottom: '.5ex' }), 'File'), dom.input(attr.type('file'), attr.required(''), attr.name('file'), function focus() {
 		mailboxFileHint.style.display = '';
-	})), mailboxFileHint = dom.p(style({ display: 'none', fontStyle: 'italic', marginTop: '.5ex' }), 'This file must either be a zip file or a gzipped tar file with mbox and/or maildir mailboxes. For maildirs, an optional file "dovecot-keywords" is read additional keywords, like Forwarded/Junk/NotJunk. If an imported mailbox already exists by name, messages are added to the existing mailbox. If a mailbox does not yet exist it will be created.')), dom.div(style({ marginBottom: '1ex' }), dom.label(dom.div(style({ marginBottom: '.5ex' }), 'Skip mailbox prefix (optional)'), dom.input(attr.name('skipMailboxPrefix'), function focus() {
+	})), mailboxFileHint = dom.p(style({ display: 'none', fontStyle: 'italic', marginTop: '.5ex' }), 'This file must either be a zip file or a gzipped tar file with mbox and/or maildir mailboxes, or directories with .eml files, or an Outlook .pst file. For maildirs, an optional file "dovecot-keywords" is read additional keywords, like Forwarded/Junk/NotJunk. For directories with .eml files, an optional file "metadata.json", as written by an EML export, is read for flags and received times. If an imported mailbox already exists by name, messages are added to the existing mailbox. If a mailbox does not yet exist it will be created.')), dom.div(style({ marginBottom: '1ex' }), dom.label(dom.div(style({ marginBottom: '.5ex' }), 'Skip mailbox prefix (optional)'), dom.input(attr.name('skipMailboxPrefix'), function focus() {
 		mailboxPrefixHint.style.display = '';
-	})), mailboxPrefixHint = dom.p(style({ display: 'none', fontStyle: 'italic', marginTop: '.5ex' }), 'If set, any mbox/maildir path with this prefix will have it stripped before importing. For example, if all mailboxes are in a directory "Takeout", specify that path in the field above so mailboxes like "Takeout/Inbox.mbox" are imported into a mailbox called "Inbox" instead of "Takeout/Inbox".')), dom.div(dom.submitbutton('Upload and import'), dom.p(style({ fontStyle: 'italic', marginTop: '.5ex' }), 'The file is uploaded first, then its messages are imported, finally messages are matched for threading. Importing is done in a transaction, you can abort the entire import before it is finished.')))), importAbortBox = dom.div(), // Outside fieldset because it gets disabled, above progress because may be scrolling it down quickly with problems.
-	importProgress = dom.div(style({ display: 'none' })), dom.br(), footer);
+	})), mailboxPrefixHint = dom.p(style({ display: 'none', fontStyle: 'italic', marginTop: '.5ex' }), 'If set, any mbox/maildir path with this prefix will have it stripped before importing. For example, if all mailboxes are in a directory "Takeout", specify that path in the field above so mailboxes like "Takeout/Inbox.mbox" are imported into a mailbox called "Inbox" instead of "Takeout/Inbox".')), dom.div(dom.submitbutton('Upload and import'), dom.p(style({ fontStyle: 'italic', marginTop: '.5ex' }), 'The file is uploaded first, then its messages are imported, finally messages are matched for threading. Importing is done in a transaction, you can abort the entire import before it is finished.')))), dom.br(), dom.h3('Migrate from IMAP server'), dom.p('Copy all mailboxes and messages, with their flags and receive times, from an account at another IMAP server. The account at the other server is not changed. Running the migration again later adds new messages and updates flags of migrated messages, so you can keep the accounts in sync until you switch over. Messages removed at the other server after migrating are kept.'), dom.form(async function submit(e) {
+		e.preventDefault();
+		e.stopPropagation();
+		migrateFieldset.disabled = true;
+		importFieldset.disabled = true;
+		try {
+			const result = await client.ImportIMAP(migrateHost.value, migrateStartTLS.checked ? 'starttls' : 'tls', migrateUsername.value, migratePassword.value, migratePrefix.value);
+			migratePassword.value = '';
+			try {
+				window.sessionStorage.setItem('ImportToken', result.Token);
+				window.sessionStorage.setItem('ImportMigration', 'yes');
+			}
+			catch (err) {
+				console.log('storing import token in session storage', { err });
+				// Ignore error, could be some browser security thing like private browsing.
+			}
+			dom._kids(importProgress);
+			importProgress.style.display = '';
+			await importTrack(result.Token, true);
+		}
+		catch (err) {
+			console.log({ err });
+			window.alert('Error: ' + errmsg(err));
+		}
+		finally {
+			migrateFieldset.disabled = false;
+			importFieldset.disabled = false;
+		}
+	}, migrateFieldset = dom.fieldset(dom.div(style({ display: 'flex', gap: '1em', flexWrap: 'wrap', marginBottom: '1ex' }), dom.label(dom.div(style({ marginBottom: '.5ex' }), 'Host', attr.title('Host name of the IMAP server, with optional port. The default port is 993, or 143 with STARTTLS.')), migrateHost = dom.input(attr.required(''), attr.placeholder('imap.example.com'))), dom.label(dom.div(style({ marginBottom: '.5ex' }), 'Username'), migrateUsername = dom.input(attr.required(''), attr.autocomplete('off'))), dom.label(dom.div(style({ marginBottom: '.5ex' }), 'Password'), migratePassword = dom.input(attr.type('password'), attr.required(''), attr.autocomplete('off'))), dom.label(dom.div(style({ marginBottom: '.5ex' }), 'Mailbox prefix (optional)', attr.title('If set, mailboxes are migrated as child mailboxes of this mailbox. Otherwise, mailboxes like Sent and Trash are migrated into the local mailboxes with the same role.')), migratePrefix = dom.input())), dom.div(style({ marginBottom: '1ex' }), dom.label(migrateStartTLS = dom.input(attr.type('checkbox')), ' Use STARTTLS instead of TLS', attr.title('Connect without TLS, typically to port 143, and then switch to TLS with STARTTLS.'))), dom.div(dom.submitbutton('Migrate')))), importAbortBox = dom.div(), // Outside fieldset because it gets disabled, above progress because may be scrolling it down quickly with problems.
+	importProgress = dom.div(style({ display: 'none' })), dom.br(), dom.h2(_('Delete account')), dom.p('Deleting your account disables logins immediately. Your account and all its data, including messages, are removed after 30 days. Until then, messages for your addresses are still accepted, and an admin can cancel the deletion. Download a takeout archive first if you want to keep your data.'), dom.form(deleteFieldset = dom.fieldset(dom.label(style({ display: 'inline-block' }), 'Current password', dom.br(), deletePassword = dom.input(attr.type('password'), attr.autocomplete('current-password'), attr.required(''))), ' ', dom.submitbutton('Delete account')), async function submit(e) {
+		e.stopPropagation();
+		e.preventDefault();
+		if (!window.confirm('Are you sure you want to delete your account? You will be logged out and can no longer log in.')) {
+			return;
+		}
+		const purgeAfter = await check(deleteFieldset, client.AccountDelete(deletePassword.value));
+		window.alert('Account scheduled for deletion, it will be removed after ' + purgeAfter.toLocaleString() + '.');
+		window.location.reload();
+	}), dom.br(), footer);
 	// Try to show the progress of an earlier import session. The user may have just
 	// refreshed the browser.
 	let importToken;
+	let importMigration;
 	try {
 		importToken = window.sessionStorage.getItem('ImportToken') || '';
+		importMigration = !!window.sessionStorage.getItem('ImportMigration');
 	}
 	catch (err) {
 		console.log('looking up ImportToken in session storage', { err });
@@ -1566,18 +2540,21 @@ const index = async () => {
 		return;
 	}
 	importFieldset.disabled = true;
+	migrateFieldset.disabled = true;
 	dom._kids(importProgress, dom.div(dom.div('Reconnecting to import...')));
 	importProgress.style.display = '';
-	importTrack(importToken)
+	importTrack(importToken, importMigration)
 		.catch(() => {
 		if (window.confirm('Error reconnecting to import. Remove this import session?')) {
 			window.sessionStorage.removeItem('ImportToken');
+			window.sessionStorage.removeItem('ImportMigration');
 			dom._kids(importProgress);
 			importProgress.style.display = 'none';
 		}
 	})
 		.finally(() => {
 		importFieldset.disabled = false;
+		migrateFieldset.disabled = false;
 	});
 };
 const destination = async (name) => {
@@ -1690,9 +2667,119 @@ const destination = async (name) => {
 		};
 		await check(saveButton, client.DestinationSave(name, dest, newDest));
 		window.location.reload(); // todo: only refresh part of ui
-	}), dom.br(), dom.br(), dom.br(), dom.p("Apple's mail applications don't do account autoconfiguration, and when adding an account it can choose defaults that don't work with modern email servers. Adding an account through a \"mobileconfig\" profile file can be more convenient: It contains the IMAP/SMTP settings such as host name, port, TLS, authentication mechanism and user name. This profile does not contain a login password. Opening the profile adds it under Profiles in System Preferences (macOS) or Settings (iOS), where you can install it. These profiles are not signed, so users will have to ignore the warnings about them being unsigned. ", dom.br(), dom.a(attr.href('https://autoconfig.' + domainName(acc.DNSDomain) + '/profile.mobileconfig?addresses=' + encodeURIComponent(addresses.join(',')) + '&name=' + encodeURIComponent(dest.FullName)), attr.download(''), 'Download .mobileconfig email account profile'), dom.br(), dom.a(attr.href('https://autoconfig.' + domainName(acc.DNSDomain) + '/profile.mobileconfig.qrcode.png?addresses=' + encodeURIComponent(addresses.join(',')) + '&name=' + encodeURIComponent(dest.FullName)), attr.download(''), 'Open QR-code with link to .mobileconfig profile')));
+	}), dom.br(), dom.br(), dom.br(), dom.p("Apple's mail applications don't do account autoconfiguration, and when adding an account it can choose defaults that don't work with modern email servers. Adding an account through a \"mobileconfig\" profile file can be more convenient: It contains the IMAP/SMTP settings such as host name, port, TLS, authentication mechanism and user name. This profile does not contain a login password. Opening the profile adds it under Profiles in System Preferences (macOS) or Settings (iOS), where you can install it. Profiles are signed with the TLS certificate of the autoconfig host name, so they are shown as verified. ", dom.br(), dom.a(attr.href('https://autoconfig.' + domainName(acc.DNSDomain) + '/profile.mobileconfig?addresses=' + encodeURIComponent(addresses.join(',')) + '&name=' + encodeURIComponent(dest.FullName)), attr.download(''), 'Download .mobileconfig email account profile'), dom.br(), dom.a(attr.href('https://autoconfig.' + domainName(acc.DNSDomain) + '/profile.mobileconfig.qrcode.png?addresses=' + encodeURIComponent(addresses.join(',')) + '&name=' + encodeURIComponent(dest.FullName)), attr.download(''), 'Open QR-code with link to .mobileconfig profile')));
+};
+const clientSettings = async (address) => {
+	const cs = await client.ClientSettings(address);
+	const security = (s) => ({ tls: 'SSL/TLS', starttls: 'STARTTLS', none: 'None' })[s] || s;
+	let jsonURL = '';
+	if (cs.Autoconfig) {
+		const u = new URL(cs.Autoconfig);
+		u.pathname = '/client-settings.json';
+		jsonURL = u.toString();
+	}
+	dom._kids(page, crumbs(crumblink('Mox Account', '#'), 'Client settings for ' + address), dom.p('Settings for configuring email, calendar and contacts applications. Log in with email address ', dom.b(prewrap(cs.Address)), ' and your password.', jsonURL ? [' The settings are also available ', dom.a(attr.href(jsonURL), 'as JSON'), ', for integrators.'] : []), dom.table(dom.thead(dom.tr(dom.th('Service'), dom.th('Host'), dom.th('Port'), dom.th('Security'))), dom.tbody(dom.tr(dom.td('IMAP (incoming)'), dom.td(cs.IMAP.Host), dom.td('' + cs.IMAP.Port), dom.td(security(cs.IMAP.Security))), dom.tr(dom.td('SMTP (outgoing)'), dom.td(cs.Submission.Host), dom.td('' + cs.Submission.Port), dom.td(security(cs.Submission.Security))))), dom.br(), dom.table(dom.thead(dom.tr(dom.th('Service'), dom.th('URL'))), dom.tbody([
+		['CalDAV/CardDAV', cs.DAV],
+		['Webmail', cs.Webmail],
+		['Account', cs.Account],
+		['WebAPI', cs.WebAPI],
+		['Autoconfig', cs.Autoconfig],
+		['Autodiscover', cs.Autodiscover],
+		['Apple profile', cs.MobileConfig],
+	].filter(t => t[1]).map(t => dom.tr(dom.td(t[0]), dom.td(dom.a(attr.href(t[1]), t[1])))))), (cs.Clients || []).map(c => [
+		dom.br(),
+		dom.h2(c.Client),
+		dom.ol((c.Steps || []).map(s => dom.li(s))),
+	]));
+};
+const quarantine = async () => {
+	const messages = await client.Quarantined() || [];
+	dom._kids(page, crumbs(crumblink('Mox Account', '#'), 'Quarantine'), dom.p('Incoming messages held in quarantine because they look suspicious, e.g. like spam or malware. Release a message to deliver it to your mailbox. Messages are removed automatically when they expire.'), dom.table(dom.thead(dom.tr(dom.th('Received'), dom.th('From'), dom.th('To'), dom.th('Subject'), dom.th('Reason'), dom.th('Expires'), dom.th('Action'))), dom.tbody(messages.length === 0 ? dom.tr(dom.td(attr.colspan('7'), '(None)')) : [], messages.map(qm => dom.tr(dom.td(age(qm.Received)), dom.td(qm.MsgFrom || qm.MailFrom), dom.td(qm.RcptTo), dom.td(qm.Subject), dom.td(qm.Reason), dom.td(qm.Expires.toLocaleString()), dom.td(dom.clickbutton('Headers', async function click(e) {
+		const headers = await check(e.target, client.QuarantineHeaders(qm.ID));
+		popup(dom.h1('Headers'), dom.pre(style({ maxWidth: '80em', whiteSpace: 'pre-wrap' }), headers));
+	}), ' ', dom.clickbutton('Release', async function click(e) {
+		await check(e.target, client.QuarantineRelease(qm.ID));
+		window.location.reload(); // todo: reload less
+	}), ' ', dom.clickbutton('Remove', async function click(e) {
+		if (!window.confirm('Are you sure you want to remove this message?')) {
+			return;
+		}
+		await check(e.target, client.QuarantineRemove(qm.ID));
+		window.location.reload(); // todo: reload less
+	})))))));
+};
+const contacts = async () => {
+	const l = await client.Contacts() || [];
+	let editing = null;
+	let fieldset;
+	let name;
+	let emails;
+	let phones;
+	let organization;
+	let notes;
+	let formTitle;
+	let importFile;
+	const split = (s) => s.split(/[\n,]+/).map(s => s.trim()).filter(s => s);
+	const edit = (c) => {
+		editing = c;
+		formTitle.textContent = c ? 'Edit contact' : 'Add contact';
+		name.value = c?.Name || '';
+		emails.value = (c?.Emails || []).join('\n');
+		phones.value = (c?.Phones || []).join('\n');
+		organization.value = c?.Organization || '';
+		notes.value = c?.Notes || '';
+	};
+	dom._kids(page, crumbs(crumblink('Mox Account', '#'), 'Contacts'), dom.p('Contacts are used for completing recipient addresses in webmail. Recipients of messages you send are added automatically, marked as harvested. Phones and desktop clients can synchronize the address book with CardDAV, logging in with your email address and password.'), dom.table(dom.thead(dom.tr(dom.th('Name'), dom.th('Email addresses'), dom.th('Phone numbers'), dom.th('Organization'), dom.th('Harvested', attr.title('Added automatically as recipient of a sent message.')), dom.th('Action'))), dom.tbody(l.length === 0 ? dom.tr(dom.td(attr.colspan('6'), '(None)')) : [], l.map(c => dom.tr(dom.td(c.Name), dom.td((c.Emails || []).join(', ')), dom.td((c.Phones || []).join(', ')), dom.td(c.Organization), dom.td(c.Harvested ? 'Yes' : ''), dom.td(dom.clickbutton('Edit', function click() {
+		edit(c);
+		name.focus();
+	}), ' ', dom.clickbutton('Remove', async function click(e) {
+		if (!window.confirm('Are you sure you want to remove this contact?')) {
+			return;
+		}
+		await check(e.target, client.ContactRemove(c.ID));
+		window.location.reload(); // todo: reload less
+	})))))), dom.br(), formTitle = dom.h2('Add contact'), dom.form(async function submit(e) {
+		e.preventDefault();
+		e.stopPropagation();
+		const c = {
+			ID: 0,
+			UID: '',
+			Href: '',
+			Created: new Date(),
+			Updated: new Date(),
+			Harvested: false,
+			...(editing || {}),
+			Name: name.value,
+			Emails: split(emails.value),
+			Phones: split(phones.value),
+			Organization: organization.value,
+			Notes: notes.value,
+		};
+		await check(fieldset, client.ContactSave(c));
+		window.location.reload(); // todo: reload less
+	}, fieldset = dom.fieldset(dom.div(style({ display: 'flex', gap: '1em', flexWrap: 'wrap', alignItems: 'flex-start' }), dom.label(style({ display: 'inline-block' }), 'Name', dom.br(), name = dom.input()), dom.label(style({ display: 'inline-block' }), dom.span('Email addresses', attr.title('One per line.')), dom.br(), emails = dom.textarea(attr.rows('3'))), dom.label(style({ display: 'inline-block' }), dom.span('Phone numbers', attr.title('One per line.')), dom.br(), phones = dom.textarea(attr.rows('3'))), dom.label(style({ display: 'inline-block' }), 'Organization', dom.br(), organization = dom.input()), dom.label(style({ display: 'inline-block' }), 'Notes', dom.br(), notes = dom.textarea(attr.rows('3')))), dom.div(style({ marginTop: '1ex' }), dom.submitbutton('Save'), ' ', dom.clickbutton('Cancel', function click() {
+		edit(null);
+	})))), dom.br(), dom.h2('Import and export'), dom.p('Import contacts from a vCard file (.vcf), e.g. exported from another address book. Contacts with the same UID are updated.'), dom.form(async function submit(e) {
+		e.preventDefault();
+		e.stopPropagation();
+		const f = (importFile.files || [])[0];
+		if (!f) {
+			return;
+		}
+		const vcards = await f.text();
+		const n = await check(e.target, client.ContactsImport(vcards));
+		window.alert('Imported ' + n + ' contact(s).');
+		window.location.reload(); // todo: reload less
+	}, importFile = dom.input(attr.type('file'), attr.required('')), ' ', dom.submitbutton('Import')), dom.br(), dom.clickbutton('Export all contacts', async function click(e) {
+		const vcards = await check(e.target, client.ContactsExport());
+		const a = dom.a(attr.href(URL.createObjectURL(new Blob([vcards], { type: 'text/vcard' }))), attr.download('contacts.vcf'));
+		a.click();
+		setTimeout(() => URL.revokeObjectURL(a.href), 1000);
+	}));
 };
 const init = async () => {
+	await i18nLoad();
+	await oidcLoginFinish();
 	let curhash;
 	const hashChange = async () => {
 		if (curhash === window.location.hash) {
@@ -1711,6 +2798,15 @@ const init = async () => {
 			else if (t[0] === 'destinations' && t.length === 2) {
 				await destination(t[1]);
 			}
+			else if (t[0] === 'clientsettings' && t.length === 2) {
+				await clientSettings(t[1]);
+			}
+			else if (h === 'contacts') {
+				await contacts();
+			}
+			else if (h === 'quarantine') {
+				await quarantine();
+			}
 			else {
 				dom._kids(page, 'page not found');
 			}
@@ -7,14 +7,79 @@ declare let moxgoversion: string
 declare let moxgoos: string
 declare let moxgoarch: string
 
+// Conversion between base64url strings in the API and binary values for WebAuthn.
+const base64urlDecode = (s: string) => Uint8Array.from(atob(s.replace(/-/g, '+').replace(/_/g, '/')), c => c.charCodeAt(0))
+const base64urlEncode = (buf: ArrayBuffer) => btoa(String.fromCharCode(...new Uint8Array(buf))).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '')
+
+// passkeyLogin asks the browser for a passkey and logs in with it, returning the
+// CSRF token.
+const passkeyLogin = async () => {
+	const opts = await client.PasskeyLoginPrep()
+	const cred = await window.navigator.credentials.get({
+		publicKey: {
+			challenge: base64urlDecode(opts.Challenge),
+			rpId: opts.RPID,
+			timeout: opts.Timeout,
+			userVerification: 'required',
+		},
+	}) as PublicKeyCredential | null
+	if (!cred) {
+		throw new Error('no passkey selected')
+	}
+	const resp = cred.response as AuthenticatorAssertionResponse
+	// The login token cookie is short-lived, so only request it now.
+	const loginToken = await client.LoginPrep()
+	return await client.PasskeyLogin(loginToken, {
+		CredentialID: cred.id,
+		ClientDataJSON: base64urlEncode(resp.clientDataJSON),
+		AuthenticatorData: base64urlEncode(resp.authenticatorData),
+		Signature: base64urlEncode(resp.signature),
+		UserHandle: resp.userHandle ? base64urlEncode(resp.userHandle) : '',
+	})
+}
+
+// passkeyCreate asks the browser to create a new passkey for registration.
+const passkeyCreate = async (opts: api.PasskeyCreationOptions): Promise<api.PasskeyAttestation> => {
+	const cred = await window.navigator.credentials.create({
+		publicKey: {
+			challenge: base64urlDecode(opts.Challenge),
+			rp: {id: opts.RPID, name: opts.RPName},
+			user: {id: base64urlDecode(opts.UserID), name: opts.UserName, displayName: opts.UserDisplayName},
+			pubKeyCredParams: (opts.Algorithms || []).map(alg => ({type: 'public-key' as const, alg: alg})),
+			excludeCredentials: (opts.ExcludeCredentialIDs || []).map(id => ({type: 'public-key' as const, id: base64urlDecode(id)})),
+			authenticatorSelection: {residentKey: 'required', userVerification: 'required'},
+			attestation: 'none',
+			timeout: opts.Timeout,
+		},
+	}) as PublicKeyCredential | null
+	if (!cred) {
+		throw new Error('no passkey created')
+	}
+	const resp = cred.response as AuthenticatorAttestationResponse
+	return {
+		ClientDataJSON: base64urlEncode(resp.clientDataJSON),
+		AttestationObject: base64urlEncode(resp.attestationObject),
+	}
+}
+
 const login = async (reason: string) => {
-	return new Promise<string>((resolve: (v: string) => void, _) => {
+	// Single sign-on is optional, don't prevent a login if we cannot find out.
+	let oidcEnabled = false
+	try {
+		oidcEnabled = await client.OIDCEnabled()
+	} catch (err) {
+		console.log('checking single sign-on', err)
+	}
+
+	return new Promise<string>((resolve: (v: string) => void, _reject) => {
 		const origFocus = document.activeElement
 		let reasonElem: HTMLElement
 		let fieldset: HTMLFieldSetElement
 		let autosize: HTMLElement
 		let username: HTMLInputElement
 		let password: HTMLInputElement
+		let totpBox: HTMLElement
+		let totpCode: HTMLInputElement
 
 		const root = dom.div(
 			style({position: 'absolute', top: 0, right: 0, bottom: 0, left: 0, backgroundColor: '#eee', display: 'flex', alignItems: 'center', justifyContent: 'center', zIndex: '1', animation: 'fadein .15s ease-in'}),
@@ -32,7 +97,7 @@ const login = async (reason: string) => {
 							try {
 								fieldset.disabled = true
 								const loginToken = await client.LoginPrep()
-								const token = await client.Login(loginToken, username.value, password.value)
+								const token = await client.Login(loginToken, username.value, password.value, totpCode.value)
 								try {
 									window.localStorage.setItem('webaccountaddress', username.value)
 									window.localStorage.setItem('webaccountcsrftoken', token)
@@ -45,6 +110,13 @@ const login = async (reason: string) => {
 								}
 								resolve(token)
 							} catch (err) {
+								if ((err as any).code === 'user:totpRequired') {
+									fieldset.disabled = false
+									totpBox.style.display = ''
+									totpCode.required = true
+									totpCode.focus()
+									return
+								}
 								console.log('login error', err)
 								window.alert('Error: ' + errmsg(err))
 							} finally {
@@ -52,10 +124,10 @@ const login = async (reason: string) => {
 							}
 						},
 						fieldset=dom.fieldset(
-							dom.h1('Account'),
+							dom.h1(_('Account')),
 							dom.label(
 								style({display: 'block', marginBottom: '2ex'}),
-								dom.div('Email address', style({marginBottom: '.5ex'})),
+								dom.div(_('Email address'), style({marginBottom: '.5ex'})),
 								autosize=dom.span(dom._class('autosize'),
 									username=dom.input(
 										attr.required(''),
@@ -67,12 +139,56 @@ const login = async (reason: string) => {
 							),
 							dom.label(
 								style({display: 'block', marginBottom: '2ex'}),
-								dom.div('Password', style({marginBottom: '.5ex'})),
+								dom.div(_('Password'), style({marginBottom: '.5ex'})),
 								password=dom.input(attr.type('password'), attr.required('')),
 							),
+							totpBox=dom.label(
+								style({display: 'none', marginBottom: '2ex'}),
+								dom.div(_('Two-factor authentication code'), style({marginBottom: '.5ex'})),
+								totpCode=dom.input(attr.autocomplete('one-time-code')),
+							),
 							dom.div(
 								style({textAlign: 'center'}),
-								dom.submitbutton('Login'),
+								dom.submitbutton(_('Login')),
+								window.PublicKeyCredential ? [
+									' ',
+									dom.clickbutton(_('Login with passkey'), async function click() {
+										reasonElem.remove()
+
+										try {
+											fieldset.disabled = true
+											const token = await passkeyLogin()
+											try {
+												window.localStorage.setItem('webaccountcsrftoken', token)
+											} catch (err) {
+												console.log('saving csrf token in localStorage', err)
+											}
+											root.remove()
+											if (origFocus && origFocus instanceof HTMLElement && origFocus.parentNode) {
+												origFocus.focus()
+											}
+											resolve(token)
+										} catch (err) {
+											console.log('passkey login error', err)
+											window.alert('Error: ' + errmsg(err))
+										} finally {
+											fieldset.disabled = false
+										}
+									}),
+								] : [],
+								oidcEnabled ? [
+									' ',
+									dom.clickbutton(_('Login with single sign-on'), async function click() {
+										try {
+											fieldset.disabled = true
+											window.location.href = await client.OIDCLoginPrep()
+										} catch (err) {
+											console.log('single sign-on error', err)
+											window.alert('Error: ' + errmsg(err))
+											fieldset.disabled = false
+										}
+									}),
+								] : [],
 							),
 						),
 					)
@@ -84,6 +200,37 @@ const login = async (reason: string) => {
 	})
 }
 
+// oidcLoginFinish finishes a single sign-on login when the identity provider sent
+// the browser back with an authorization code.
+const oidcLoginFinish = async () => {
+	const params = new URLSearchParams(window.location.search)
+	const code = params.get('code')
+	const state = params.get('state')
+	const error = params.get('error')
+	if (!state || (!code && !error)) {
+		return
+	}
+	// Remove the parameters, they can only be used once.
+	window.history.replaceState(null, '', window.location.pathname + window.location.hash)
+	if (error) {
+		window.alert('Error: single sign-on failed: ' + (params.get('error_description') || error))
+		return
+	}
+	try {
+		const loginToken = await client.LoginPrep()
+		const token = await client.OIDCLogin(loginToken, code || '', state)
+		try {
+			window.localStorage.setItem('webaccountcsrftoken', token)
+		} catch (err) {
+			console.log('saving csrf token in localStorage', err)
+		}
+		client.authState.token = token
+	} catch (err) {
+		console.log('single sign-on login error', err)
+		window.alert('Error: ' + errmsg(err))
+	}
+}
+
 // Popup shows kids in a centered div with white background on top of a
 // transparent overlay on top of the window. Clicking the overlay or hitting
 // Escape closes the popup. Scrollbars are automatically added to the div with
@@ -178,7 +325,7 @@ const crumbs = (...l: ({text: string, path: string} | string)[]) => {
 			style({float: 'right'}),
 			localStorageGet('webaccountaddress') || '(unknown)',
 			' ',
-			dom.clickbutton('Logout', attr.title('Logout, invalidating this session.'), async function click(e: MouseEvent) {
+			dom.clickbutton(_('Logout'), attr.title(_('Logout, invalidating this session.')), async function click(e: MouseEvent) {
 				const b = e.target! as HTMLButtonElement
 				try {
 					b.disabled = true
@@ -295,6 +442,29 @@ const formatQuotaSize = (v: number) => {
 
 const index = async () => {
 	const [acc, storageUsed, storageLimit, suppressions] = await client.Account()
+	const sessions = await client.ProtocolSessions() || []
+	const appPasswords = await client.AppPasswords() || []
+	const [totpEnabled, totpRequired, totpRecoveryCodesLeft] = await client.TOTPStatus()
+	const [passwordChangeRequired, passwordMinLength, passwordMinEntropy, passwordBreachCheck] = await client.PasswordStatus()
+	const passkeys = await client.Passkeys() || []
+	const pushSubscriptions = await client.PushSubscriptions() || []
+	const remoteContentSenders = await client.RemoteContentSenders() || []
+	const senderList = await client.SenderList() || []
+	const [ownedAliasesList, maxAliases] = await client.AliasesOwned()
+	const ownedAliases = ownedAliasesList || []
+	const identities = await client.Identities() || []
+	const quarantined = await client.Quarantined() || []
+	const language = await client.Language()
+
+	// The language configured for the account, e.g. in another browser, takes
+	// precedence.
+	if (language && language !== i18nPreference()) {
+		i18nPreferenceSet(language)
+		if (language !== i18nLang) {
+			window.location.reload()
+			return
+		}
+	}
 
 	let fullNameForm: HTMLFormElement
 	let fullNameFieldset: HTMLFieldSetElement
@@ -304,6 +474,19 @@ const index = async () => {
 	let password1: HTMLInputElement
 	let password2: HTMLInputElement
 	let passwordHint: HTMLElement
+	let languageFieldset: HTMLFieldSetElement
+	let languageSelect: HTMLSelectElement
+	let remoteContentSender: HTMLInputElement
+	let senderListSender: HTMLInputElement
+	let senderListAction: HTMLSelectElement
+	let aliasLocalpart: HTMLInputElement
+	let aliasDomain: HTMLSelectElement
+	let aliasMember: HTMLSelectElement
+	let aliasAllowMsgFrom: HTMLInputElement
+	let identityAddress: HTMLSelectElement
+	let identityFullName: HTMLInputElement
+	let identityReplyTo: HTMLInputElement
+	let identitySignature: HTMLTextAreaElement
 
 	let autoJunkFlagsFieldset: HTMLFieldSetElement
 	let autoJunkFlagsEnabled: HTMLInputElement
@@ -311,6 +494,9 @@ const index = async () => {
 	let neutralMailboxRegexp: HTMLInputElement
 	let notJunkMailboxRegexp: HTMLInputElement
 
+	let junkTrainingFieldset: HTMLFieldSetElement
+	let junkDelayFlagTraining: HTMLInputElement
+
 	let rejectsFieldset: HTMLFieldSetElement
 	let rejectsMailbox: HTMLInputElement
 	let keepRejects: HTMLInputElement
@@ -330,6 +516,14 @@ const index = async () => {
 
 	let fromIDLoginAddressesFieldset: HTMLFieldSetElement
 
+	let totpSetupBox: HTMLElement
+
+	let passkeyFieldset: HTMLFieldSetElement
+	let passkeyLabel: HTMLInputElement
+
+	let deleteFieldset: HTMLFieldSetElement
+	let deletePassword: HTMLInputElement
+
 	const second = 1000*1000*1000
 	const minute = 60*second
 	const hour = 60*minute
@@ -370,11 +564,23 @@ const index = async () => {
 	let mailboxPrefixHint: HTMLElement
 	let importProgress: HTMLElement
 	let importAbortBox: HTMLElement
+	let migrateFieldset: HTMLFieldSetElement
+	let migrateHost: HTMLInputElement
+	let migrateStartTLS: HTMLInputElement
+	let migrateUsername: HTMLInputElement
+	let migratePassword: HTMLInputElement
+	let migratePrefix: HTMLInputElement
 
 	let suppressionAddress: HTMLInputElement
 	let suppressionReason: HTMLInputElement
 
-	const importTrack = async (token: string) => {
+	let appPasswordLabel: HTMLInputElement
+	let appPasswordIMAP: HTMLInputElement
+	let appPasswordSMTP: HTMLInputElement
+	let appPasswordIPNets: HTMLInputElement
+
+	// For migrations from a remote IMAP server, messages added before an abort are kept.
+	const importTrack = async (token: string, migration: boolean) => {
 		const importConnection = dom.div('Waiting for updates...')
 		importProgress.appendChild(importConnection)
 
@@ -390,7 +596,7 @@ const index = async () => {
 				dom._kids(importConnection, dom.div('Waiting for updates, connected...'))
 
 				dom._kids(importAbortBox,
-					dom.clickbutton('Abort import', attr.title('If the import is not yet finished, it can be aborted and no messages will have been imported.'), async function click() {
+					dom.clickbutton('Abort import', attr.title(migration ? 'If the migration is not yet finished, it can be aborted. Messages added so far are kept, a next migration continues where it stopped.' : 'If the import is not yet finished, it can be aborted and no messages will have been imported.'), async function click() {
 						try {
 							await client.ImportAbort(token)
 						} catch (err) {
@@ -462,18 +668,20 @@ const index = async () => {
 				dom._kids(importConnection)
 				dom._kids(importAbortBox)
 				window.sessionStorage.removeItem('ImportToken')
+				window.sessionStorage.removeItem('ImportMigration')
 
 				resolve(null)
 			})
 			eventSource.addEventListener('aborted', function(e) {
 				console.log('import aborted event', {e})
 
-				importProgress.appendChild(dom.div(dom.br(), box(red, 'Import aborted, no message imported')))
+				importProgress.appendChild(dom.div(dom.br(), box(red, migration ? 'Migration aborted, messages added so far are kept, a next migration continues where this one stopped' : 'Import aborted, no message imported')))
 
 				eventSource.close()
 				dom._kids(importConnection)
 				dom._kids(importAbortBox)
 				window.sessionStorage.removeItem('ImportToken')
+				window.sessionStorage.removeItem('ImportMigration')
 
 				reject({message: 'Import aborted'})
 			})
@@ -729,6 +937,7 @@ const index = async () => {
 	dom._kids(page,
 		crumbs('Mox Account'),
 		dom.p('NOTE: Not all account settings can be configured through these pages yet. See the configuration file for more options.'),
+		passwordChangeRequired ? dom.p(box(red, 'An administrator requires you to change your password, see below. Until then, your password is only accepted for logging in to this account web interface, not for webmail, IMAP and SMTP submission. App passwords keep working.')) : [],
 		dom.div(
 			'Default domain: ',
 			acc.DNSDomain.ASCII ? domainString(acc.DNSDomain) : '(none)',
@@ -756,7 +965,7 @@ const index = async () => {
 		),
 		dom.br(),
 
-		dom.h2('Addresses'),
+		dom.h2(_('Addresses')),
 		dom.ul(
 			Object.entries(acc.Destinations || {}).length === 0 ? dom.li('(None, login disabled)') : [],
 			Object.entries(acc.Destinations || {}).sort().map(t =>
@@ -768,7 +977,20 @@ const index = async () => {
 		),
 		dom.br(),
 
-		dom.h2('Aliases/lists'),
+		dom.h2(_('Email clients')),
+		dom.p('Settings and setup instructions for email, calendar and contacts applications, such as Thunderbird, Outlook, iOS and macOS Mail, Thunderbird for Android/K-9 Mail and FairEmail.'),
+		dom.ul(
+			Object.keys(acc.Destinations || {}).filter(addr => !addr.startsWith('@')).sort().map(addr =>
+				dom.li(dom.a(prewrap(addr), attr.href('#clientsettings/'+encodeURIComponent(addr)))),
+			),
+		),
+		dom.br(),
+
+		dom.h2(_('Contacts')),
+		dom.p('Your address book, used for completing recipient addresses in webmail, and synchronized with phones and desktop clients through CardDAV. Recipients of messages you send are added automatically. ', dom.a(attr.href('#contacts'), 'Manage contacts'), '.'),
+		dom.br(),
+
+		dom.h2(_('Aliases/lists')),
 		dom.table(
 			dom.thead(
 				dom.tr(
@@ -796,13 +1018,145 @@ const index = async () => {
 									),
 								)
 							}),
+						' ',
+						!ownedAliases.includes(a.Alias.LocalpartStr+'@'+domainName(a.Alias.Domain)) ? [] :
+							dom.clickbutton('Remove', async function click(e: MouseEvent) {
+								if (!window.confirm('Are you sure you want to remove this alias?')) {
+									return
+								}
+								await check(e.target! as HTMLButtonElement, client.AliasDelete(a.Alias.LocalpartStr+'@'+domainName(a.Alias.Domain)))
+								window.location.reload() // todo: reload less
+							}),
+					),
+				),
+			),
+		),
+		dom.br(),
+		maxAliases <= 0 ? [] : [
+			dom.h2(_('Create alias')),
+			dom.p('Create an alias address in one of your domains, delivering to one of your addresses. You can remove aliases you created. You have created ', ''+ownedAliases.length, ' of at most ', ''+maxAliases, ' aliases.'),
+			dom.form(
+				async function submit(e: SubmitEvent) {
+					e.preventDefault()
+					e.stopPropagation()
+
+					await check(e.target! as HTMLButtonElement, client.AliasCreate(aliasLocalpart.value, aliasDomain.value, aliasMember.value, aliasAllowMsgFrom.checked))
+					window.location.reload() // todo: reload less
+				},
+				dom.fieldset(
+					ownedAliases.length >= maxAliases ? attr.disabled('') : [],
+					dom.label(
+						style({display: 'inline-block'}),
+						'Localpart',
+						dom.br(),
+						aliasLocalpart=dom.input(attr.required('')),
+					),
+					'@',
+					dom.label(
+						style({display: 'inline-block'}),
+						'Domain',
+						dom.br(),
+						aliasDomain=dom.select(
+							attr.required(''),
+							[...new Set(Object.keys(acc.Destinations || {}).map(s => s.substring(s.lastIndexOf('@')+1)))].sort().map(d => dom.option(d)),
+						),
+					),
+					' ',
+					dom.label(
+						style({display: 'inline-block'}),
+						'Deliver to',
+						dom.br(),
+						aliasMember=dom.select(
+							attr.required(''),
+							Object.keys(acc.Destinations || {}).filter(s => !s.startsWith('@')).sort().map(s => dom.option(s)),
+						),
+					),
+					' ',
+					dom.label(
+						style({display: 'inline-block'}),
+						aliasAllowMsgFrom=dom.input(attr.type('checkbox'), attr.checked('')),
+						' Allow sending as alias address',
+					),
+					' ',
+					dom.submitbutton('Create alias'),
+				),
+			),
+			dom.br(),
+		],
+
+		dom.h2(_('Sending identities')),
+		dom.p('Settings for composing messages in webmail with one of your addresses as From address: a display name instead of your full name, a Reply-To address, and a signature instead of the signature in the webmail settings.'),
+		dom.form(
+			attr.id('identitySave'),
+			async function submit(e: SubmitEvent) {
+				e.preventDefault()
+				e.stopPropagation()
+
+				const identity: api.Identity = {
+					ID: 0,
+					Address: identityAddress.value,
+					FullName: identityFullName.value,
+					ReplyTo: identityReplyTo.value,
+					Signature: identitySignature.value,
+				}
+				await check(e.target! as HTMLButtonElement, client.IdentitySave(identity))
+				window.location.reload() // todo: reload less
+			},
+		),
+		dom.table(
+			dom.thead(
+				dom.tr(
+					dom.th('Address'),
+					dom.th('Name'),
+					dom.th('Reply-To'),
+					dom.th('Signature'),
+					dom.th('Action'),
+				),
+			),
+			dom.tbody(
+				identities.length === 0 ? dom.tr(dom.td(attr.colspan('5'), '(None)')) : [],
+				identities.map(ident =>
+					dom.tr(
+						dom.td(prewrap(ident.Address)),
+						dom.td(ident.FullName),
+						dom.td(ident.ReplyTo),
+						dom.td(style({whiteSpace: 'pre-wrap'}), ident.Signature),
+						dom.td(
+							dom.clickbutton('Edit', function click() {
+								identityAddress.value = ident.Address
+								identityFullName.value = ident.FullName
+								identityReplyTo.value = ident.ReplyTo
+								identitySignature.value = ident.Signature
+								identityFullName.focus()
+							}),
+							' ',
+							dom.clickbutton('Remove', async function click(e: MouseEvent) {
+								await check(e.target! as HTMLButtonElement, client.IdentityRemove(ident.ID))
+								window.location.reload() // todo: reload less
+							}),
+						),
+					),
+				),
+			),
+			dom.tfoot(
+				dom.tr(
+					dom.td(
+						identityAddress=dom.select(
+							attr.required(''),
+							attr.form('identitySave'),
+							[...Object.keys(acc.Destinations || {}).filter(s => !s.startsWith('@')), ...(acc.Aliases || []).filter(a => a.Alias.AllowMsgFrom).map(a => a.Alias.LocalpartStr+'@'+domainName(a.Alias.Domain))].sort().map(s => dom.option(s)),
+						),
 					),
+					dom.td(identityFullName=dom.input(attr.placeholder(acc.FullName), attr.form('identitySave'))),
+					dom.td(identityReplyTo=dom.input(attr.placeholder('Optional'), attr.form('identitySave'))),
+					dom.td(identitySignature=dom.textarea(attr.rows('3'), attr.form('identitySave'))),
+					dom.td(dom.submitbutton('Save', attr.form('identitySave'))),
 				),
 			),
 		),
 		dom.br(),
 
-		dom.h2('Change password'),
+		dom.h2(_('Change password')),
 		passwordForm=dom.form(
 			passwordFieldset=dom.fieldset(
 				dom.label(
@@ -830,7 +1184,7 @@ const index = async () => {
 					let b = new Uint8Array(1)
 					let s = ''
 					const chars = 'abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789!@#$%^&*-_;:,<.>/'
-					while (s.length < 12) {
+					while (s.length < Math.max(12, passwordMinLength)) {
 						self.crypto.getRandomValues(b)
 						if (Math.ceil(b[0]/chars.length)*chars.length > 255) {
 							continue // Prevent bias.
@@ -842,6 +1196,7 @@ const index = async () => {
 					password1.value = s
 					password2.value = s
 				}),
+				dom.div(dom._class('text'), 'Requirements: at least '+passwordMinLength+' characters'+(passwordMinEntropy ? ', an estimated strength of at least '+passwordMinEntropy+' bits' : '')+(passwordBreachCheck ? ', not found in known data breaches' : '')+'.'),
 				dom.div(dom._class('text'),
 					box(yellow, 'Important: Bots will try to bruteforce your password. Connections with failed authentication attempts will be rate limited but attackers WILL find weak passwords. If your account is compromised, spammers are likely to abuse your system, spamming your address and the wider internet in your name. So please pick a random, unguessable password, preferrably at least 12 characters.'),
 				),
@@ -855,11 +1210,37 @@ const index = async () => {
 				}
 				await check(passwordFieldset, client.SetPassword(password1.value))
 				passwordForm.reset()
+				if (passwordChangeRequired) {
+					window.location.reload()
+				}
+			},
+		),
+		dom.br(),
+
+		dom.h2(_('Language')),
+		dom.form(
+			languageFieldset=dom.fieldset(
+				dom.label(
+					style({display: 'inline-block'}),
+					attr.title(_('Language of the user interface, and of messages sent to you by the mail server, such as delivery failure notifications.')),
+					languageSelect=dom.select(
+						dom.option(attr.value(''), _('Browser default')),
+						i18nLanguages.map(l => dom.option(attr.value(l.Code), l.Name, l.Code === language ? attr.selected('') : [])),
+					),
+				),
+				' ',
+				dom.submitbutton(_('Save')),
+			),
+			async function submit(e: SubmitEvent) {
+				e.preventDefault()
+				await check(languageFieldset, client.LanguageSave(languageSelect.value))
+				i18nPreferenceSet(languageSelect.value)
+				window.location.reload()
 			},
 		),
 		dom.br(),
 
-		dom.h2('Disk usage'),
+		dom.h2(_('Disk usage')),
 		dom.p('Storage used is ', dom.b(formatQuotaSize(Math.floor(storageUsed/(1024*1024))*1024*1024)),
 			storageLimit > 0 ? [
 				dom.b('/', formatQuotaSize(storageLimit)),
@@ -901,7 +1282,40 @@ const index = async () => {
 		),
 		dom.br(),
 
-		dom.h2('Rejects'),
+		!acc.JunkFilter ? [] : [
+			dom.h2('Junk filter training', attr.title('The junk filter is trained with messages that have the junk or nonjunk flag set. When the flags of a message change, e.g. because an email client moves it to or from the Junk mailbox, or sets the $Junk or $NotJunk flag, the junk filter is retrained.')),
+			dom.form(
+				async function submit(e: SubmitEvent) {
+					e.preventDefault()
+					e.stopPropagation()
+
+					await check(junkTrainingFieldset, client.JunkFilterTrainingSave(junkDelayFlagTraining.checked))
+				},
+				junkTrainingFieldset=dom.fieldset(
+					dom.label(
+						junkDelayFlagTraining=dom.input(attr.type('checkbox'), acc.JunkFilter.DelayFlagTraining ? attr.checked('') : []),
+						' Delay retraining after flag changes',
+						attr.title('If checked, the junk filter is not retrained immediately when junk/nonjunk flags change, but once a day for all messages with changed flags.'),
+					),
+					' ',
+					dom.submitbutton('Save'),
+					' ',
+					dom.clickbutton('Retrain now', attr.title('Retrain the junk filter with all messages whose junk/nonjunk flags changed since they were last trained.'), async function click(e: MouseEvent) {
+						const n = await check(e.target! as HTMLButtonElement, client.JunkFilterRetrainBacklog())
+						window.alert('Retrained ' + n + ' message(s).')
+					}),
+				),
+			),
+			dom.br(),
+		],
+
+		quarantined.length === 0 ? [] : [
+			dom.h2('Quarantine'),
+			dom.p(''+quarantined.length+' incoming message(s) held in quarantine because they look suspicious. ', dom.a(attr.href('#quarantine'), 'Review quarantined messages'), '.'),
+			dom.br(),
+		],
+
+		dom.h2(_('Rejects')),
 		dom.form(
 			async function submit(e: SubmitEvent) {
 				e.preventDefault()
@@ -927,7 +1341,7 @@ const index = async () => {
 		),
 		dom.br(),
 
-		dom.h2('Webhooks'),
+		dom.h2(_('Webhooks')),
 		dom.h3('Outgoing', attr.title('Webhooks for outgoing messages are called for each attempt to deliver a message in the outgoing queue, e.g. when the queue has delivered a message to the next hop, when a single attempt failed with a temporary error, when delivery permanently failed, or when DSN (delivery status notification) messages were received about a previously sent message.')),
 		dom.form(
 			async function submit(e: SubmitEvent) {
@@ -1125,7 +1539,7 @@ const index = async () => {
 		})(),
 		dom.br(),
 
-		dom.h2('Suppression list'),
+		dom.h2(_('Suppression list')),
 		dom.p('Messages queued for delivery to recipients on the suppression list will immediately fail. If delivery to a recipient fails repeatedly, it can be added to the suppression list automatically. Repeated rejected delivery attempts can have a negative influence of mail server reputation. Applications sending email can implement their own handling of delivery failure notifications, but not all do.'),
 		dom.form(
 			attr.id('suppressionAdd'),
@@ -1176,8 +1590,361 @@ const index = async () => {
 		),
 		dom.br(),
 
-		dom.h2('Export'),
-		dom.p('Export all messages in all mailboxes.'),
+		dom.h2(_('Two-factor authentication')),
+		dom.p('With two-factor authentication, logging in to the web interfaces requires a code from an authenticator app, in addition to your password. Once enabled, your account password can no longer be used for IMAP and SMTP submission, use app passwords instead.'),
+		totpRequired && !totpEnabled ? dom.p(box(yellow, 'Two-factor authentication is required for this account, logging in to webmail is not possible until it is set up.')) : [],
+		totpEnabled ?
+			dom.div(
+				dom.p('Two-factor authentication is enabled. Unused recovery codes: ' + totpRecoveryCodesLeft + '.'),
+				dom.clickbutton('Generate new recovery codes', async function click(e: MouseEvent) {
+					if (!window.confirm('Are you sure? Previous recovery codes will no longer work.')) {
+						return
+					}
+					const codes = await check(e.target! as HTMLButtonElement, client.TOTPRecoveryCodesReset())
+					window.alert('New recovery codes, each can be used once instead of a code from your authenticator app:\n\n' + (codes || []).join('\n') + '\n\nStore them in a safe place, they will not be shown again.')
+					window.location.reload() // todo: reload less
+				}), ' ',
+				totpRequired ? [] : dom.clickbutton('Disable', async function click(e: MouseEvent) {
+					const code = window.prompt('Code from your authenticator app, or a recovery code:')
+					if (!code) {
+						return
+					}
+					await check(e.target! as HTMLButtonElement, client.TOTPDisable(code))
+					window.location.reload() // todo: reload less
+				}),
+			) :
+			totpSetupBox=dom.div(
+				dom.clickbutton('Set up two-factor authentication', async function click(e: MouseEvent) {
+					const [secret, , qrcode] = await check(e.target! as HTMLButtonElement, client.TOTPSetup())
+					let fieldset: HTMLFieldSetElement
+					let code: HTMLInputElement
+					dom._kids(totpSetupBox,
+						dom.p('Scan the QR code with your authenticator app, or enter the secret manually. Then enter the code shown by the app to confirm.'),
+						dom.img(attr.src(qrcode)),
+						dom.pre(secret),
+						dom.form(
+							async function submit(e: SubmitEvent) {
+								e.preventDefault()
+								e.stopPropagation()
+
+								const codes = await check(fieldset, client.TOTPEnable(code.value))
+								window.alert('Two-factor authentication enabled. Recovery codes, each can be used once instead of a code from your authenticator app:\n\n' + (codes || []).join('\n') + '\n\nStore them in a safe place, they will not be shown again.')
+								window.location.reload() // todo: reload less
+							},
+							fieldset=dom.fieldset(
+								dom.label('Code ', code=dom.input(attr.required(''), attr.autocomplete('one-time-code'))), ' ',
+								dom.submitbutton('Enable'),
+							),
+						),
+					)
+					code.focus()
+				}),
+			),
+		dom.br(),
+
+		dom.h2(_('Passkeys')),
+		dom.p('Passkeys let you log in to the web interfaces without password, with a device or security key that verifies it is you, e.g. with a fingerprint or PIN. No two-factor authentication code is needed when logging in with a passkey. Passkeys cannot be used for IMAP and SMTP submission.'),
+		dom.table(
+			dom.thead(
+				dom.tr(
+					dom.th('Label'),
+					dom.th('Login address'),
+					dom.th('Created'),
+					dom.th('Last used'),
+					dom.th('Action'),
+				),
+			),
+			dom.tbody(
+				passkeys.length === 0 ? dom.tr(dom.td(attr.colspan('5'), '(None)')) : [],
+				passkeys.map(pk =>
+					dom.tr(
+						dom.td(pk.Label),
+						dom.td(pk.LoginAddress),
+						dom.td(age(pk.Created)),
+						dom.td(pk.LastUsed.getTime() > 0 ? age(pk.LastUsed) : 'Never'),
+						dom.td(
+							dom.clickbutton('Remove', async function click(e: MouseEvent) {
+								if (!window.confirm('Are you sure you want to remove this passkey?')) {
+									return
+								}
+								await check(e.target! as HTMLButtonElement, client.PasskeyRemove(pk.ID))
+								window.location.reload() // todo: reload less
+							}),
+						),
+					),
+				),
+			),
+		),
+		window.PublicKeyCredential ?
+			dom.form(
+				style({marginTop: '1ex'}),
+				async function submit(e: SubmitEvent) {
+					e.preventDefault()
+					e.stopPropagation()
+
+					await check(passkeyFieldset, (async () => {
+						const opts = await client.PasskeyRegisterPrep()
+						const response = await passkeyCreate(opts)
+						await client.PasskeyRegister(passkeyLabel.value, response)
+					})())
+					window.location.reload() // todo: reload less
+				},
+				passkeyFieldset=dom.fieldset(
+					dom.label('Label ', passkeyLabel=dom.input(attr.required(''), attr.placeholder('e.g. laptop'))), ' ',
+					dom.submitbutton('Add passkey'),
+				),
+			) :
+			dom.p('Your browser does not support passkeys.'),
+		dom.br(),
+
+		dom.h2(_('Push notifications')),
+		dom.p('Devices on which notifications about new messages are enabled in webmail. Notifications are shown while webmail is not open. Enable notifications on a device in the webmail settings.'),
+		dom.table(
+			dom.thead(
+				dom.tr(
+					dom.th('Device'),
+					dom.th('Created'),
+					dom.th('Last notification'),
+					dom.th('Last error'),
+					dom.th('Action'),
+				),
+			),
+			dom.tbody(
+				pushSubscriptions.length === 0 ? dom.tr(dom.td(attr.colspan('5'), '(None)')) : [],
+				pushSubscriptions.map(ps =>
+					dom.tr(
+						dom.td(ps.Label || '-'),
+						dom.td(age(ps.Created)),
+						dom.td(ps.LastPush.getTime() > 0 ? age(ps.LastPush) : 'Never'),
+						dom.td(ps.LastError),
+						dom.td(
+							dom.clickbutton('Test', attr.title('Send a test notification to this device.'), async function click(e: MouseEvent) {
+								await check(e.target! as HTMLButtonElement, client.PushSubscriptionTest(ps.ID))
+							}), ' ',
+							dom.clickbutton('Remove', async function click(e: MouseEvent) {
+								if (!window.confirm('Are you sure you want to remove notifications for this device?')) {
+									return
+								}
+								await check(e.target! as HTMLButtonElement, client.PushSubscriptionRemove(ps.ID))
+								window.location.reload() // todo: reload less
+							}),
+						),
+					),
+				),
+			),
+		),
+		dom.br(),
+
+		dom.h2(_('Remote content')),
+		dom.p('External resources, such as images, in HTML messages from these senders are loaded by default in webmail. Loading external resources can reveal to the sender that and when you read a message. Senders are added when you choose to show a message with external resources in webmail. Add a domain as "@domain".'),
+		dom.form(
+			attr.id('remoteContentSenderAdd'),
+			async function submit(e: SubmitEvent) {
+				e.preventDefault()
+				e.stopPropagation()
+
+				await check(e.target! as HTMLButtonElement, client.RemoteContentSenderAdd(remoteContentSender.value))
+				window.location.reload() // todo: reload less
+			},
+		),
+		dom.table(
+			dom.thead(
+				dom.tr(
+					dom.th('Sender'),
+					dom.th('Action'),
+				),
+			),
+			dom.tbody(
+				remoteContentSenders.length === 0 ? dom.tr(dom.td(attr.colspan('2'), '(None)')) : [],
+				remoteContentSenders.map(s =>
+					dom.tr(
+						dom.td(s),
+						dom.td(
+							dom.clickbutton('Remove', async function click(e: MouseEvent) {
+								await check(e.target! as HTMLButtonElement, client.RemoteContentSenderRemove(s))
+								window.location.reload() // todo: reload less
+							}),
+						),
+					),
+				),
+			),
+			dom.tfoot(
+				dom.tr(
+					dom.td(remoteContentSender=dom.input(attr.required(''), attr.placeholder('e.g. news@example.com or @example.com'), attr.form('remoteContentSenderAdd'))),
+					dom.td(dom.submitbutton('Add sender', attr.form('remoteContentSenderAdd'))),
+				),
+			),
+		),
+		dom.br(),
+
+		dom.h2('Sender lists'),
+		dom.p('Incoming messages from senders on the allow list skip junk filtering, if the sender address is verified with DMARC or SPF. Messages from senders on the reject list are refused, and messages from senders on the junk list are delivered to the Junk mailbox. Add a domain as "@domain", it also matches subdomains. These lists take precedence over the sender lists of the domain.'),
+		dom.form(
+			attr.id('senderListAdd'),
+			async function submit(e: SubmitEvent) {
+				e.preventDefault()
+				e.stopPropagation()
+
+				await check(e.target! as HTMLButtonElement, client.SenderListSet(senderListSender.value, senderListAction.value as api.SenderAction))
+				window.location.reload() // todo: reload less
+			},
+		),
+		dom.table(
+			dom.thead(
+				dom.tr(
+					dom.th('Sender'),
+					dom.th('List'),
+					dom.th('Added'),
+					dom.th('Action'),
+				),
+			),
+			dom.tbody(
+				senderList.length === 0 ? dom.tr(dom.td(attr.colspan('4'), '(None)')) : [],
+				senderList.map(e =>
+					dom.tr(
+						dom.td(e.Sender),
+						dom.td(e.Action),
+						dom.td(age(e.Created)),
+						dom.td(
+							dom.clickbutton('Remove', async function click(ev: MouseEvent) {
+								await check(ev.target! as HTMLButtonElement, client.SenderListRemove(e.ID))
+								window.location.reload() // todo: reload less
+							}),
+						),
+					),
+				),
+			),
+			dom.tfoot(
+				dom.tr(
+					dom.td(senderListSender=dom.input(attr.required(''), attr.placeholder('e.g. news@example.com or @example.com'), attr.form('senderListAdd'))),
+					dom.td(
+						senderListAction=dom.select(
+							attr.form('senderListAdd'),
+							dom.option('allow', attr.value(api.SenderAction.SenderAllow)),
+							dom.option('reject', attr.value(api.SenderAction.SenderReject)),
+							dom.option('junk', attr.value(api.SenderAction.SenderJunk)),
+						),
+					),
+					dom.td(),
+					dom.td(dom.submitbutton('Add sender', attr.form('senderListAdd'))),
+				),
+			),
+		),
+		dom.br(),
+
+		dom.h2(_('App passwords')),
+		dom.p('App passwords are random passwords for email clients on your devices, for IMAP and SMTP submission. They cannot be used to log in to the web interface. Give each device its own app password, so it can be removed individually when a device is lost. App passwords only work with authentication mechanisms that send the password, e.g. IMAP LOGIN and SASL PLAIN, not with SCRAM or CRAM-MD5.'),
+		dom.form(
+			attr.id('appPasswordAdd'),
+			async function submit(e: SubmitEvent) {
+				e.preventDefault()
+				e.stopPropagation()
+
+				const protocols = [appPasswordIMAP.checked ? 'imap' : '', appPasswordSMTP.checked ? 'smtp' : ''].filter(s => s)
+				const ipNets = appPasswordIPNets.value.split(/[\s,]+/).filter(s => s)
+				const [, password] = await check(e.target! as HTMLButtonElement, client.AppPasswordAdd(appPasswordLabel.value, protocols, ipNets))
+				window.alert('New app password:\n\n' + password + '\n\nConfigure it in your email client now, it will not be shown again.')
+				window.location.reload() // todo: reload less
+			},
+		),
+		dom.table(
+			dom.thead(
+				dom.tr(
+					dom.th('Label'),
+					dom.th('Protocols', attr.title('Protocols the app password can be used for. If none are selected, it can be used for all.')),
+					dom.th('IP networks', attr.title('If set, the app password can only be used from these IPs or networks, separated by comma or space.')),
+					dom.th('Created'),
+					dom.th('Last used'),
+					dom.th('Action'),
+				),
+			),
+			dom.tbody(
+				appPasswords.length === 0 ? dom.tr(dom.td(attr.colspan('6'), '(None)')) : [],
+				appPasswords.map(ap =>
+					dom.tr(
+						dom.td(ap.Label),
+						dom.td((ap.Protocols || []).join(', ') || 'All'),
+						dom.td((ap.IPNets || []).join(', ') || 'Any'),
+						dom.td(age(ap.Created)),
+						dom.td(ap.LastUsed.getTime() > 0 ? age(ap.LastUsed) : 'Never'),
+						dom.td(
+							dom.clickbutton('Remove', async function click(e: MouseEvent) {
+								if (!window.confirm('Are you sure you want to remove this app password? Active sessions are not closed, close them in the list of sessions below.')) {
+									return
+								}
+								await check(e.target! as HTMLButtonElement, client.AppPasswordRemove(ap.ID))
+								window.location.reload() // todo: reload less
+							}),
+						),
+					),
+				),
+			),
+			dom.tfoot(
+				dom.tr(
+					dom.td(appPasswordLabel=dom.input(attr.required(''), attr.placeholder('e.g. phone'), attr.form('appPasswordAdd'))),
+					dom.td(
+						dom.label(appPasswordIMAP=dom.input(attr.type('checkbox'), attr.form('appPasswordAdd')), ' IMAP'), ' ',
+						dom.label(appPasswordSMTP=dom.input(attr.type('checkbox'), attr.form('appPasswordAdd')), ' SMTP'),
+					),
+					dom.td(appPasswordIPNets=dom.input(attr.placeholder('e.g. 192.0.2.0/24'), attr.form('appPasswordAdd'))),
+					dom.td(),
+					dom.td(),
+					dom.td(dom.submitbutton('Add app password', attr.form('appPasswordAdd'))),
+				),
+			),
+		),
+		dom.br(),
+
+		dom.h2(_('Sessions')),
+		dom.p('Active and recent IMAP and SMTP submission sessions, from email clients on your devices. If a device is lost, you can close its sessions. The email client can log in again with your password, so also change your password.'),
+		dom.table(
+			dom.thead(
+				dom.tr(
+					dom.th('Protocol'),
+					dom.th('Login address'),
+					dom.th('Remote IP'),
+					dom.th('Client', attr.title('Client identification, from the IMAP ID command or the SMTP EHLO hostname.')),
+					dom.th('Started'),
+					dom.th('Last activity'),
+					dom.th('Status'),
+					dom.th('Action'),
+				),
+			),
+			dom.tbody(
+				sessions.length === 0 ? dom.tr(dom.td(attr.colspan('8'), '(None)')) : [],
+				sessions.map(s =>
+					dom.tr(
+						dom.td(s.Protocol),
+						dom.td(s.LoginAddress),
+						dom.td(s.RemoteIP),
+						dom.td(s.ClientID),
+						dom.td(age(s.Started)),
+						dom.td(age(s.LastActivity)),
+						dom.td(s.Active ? 'Active' : (s.Closed ? 'Closed' : 'Ended')),
+						dom.td(
+							!s.Active ? [] : dom.clickbutton('Close', async function click(e: MouseEvent) {
+								await check(e.target! as HTMLButtonElement, client.ProtocolSessionClose(s.ID))
+								window.location.reload() // todo: reload less
+							}),
+						),
+					),
+				),
+			),
+		),
+		dom.div(
+			style({marginTop: '1ex'}),
+			dom.clickbutton('Close all active sessions', async function click(e: MouseEvent) {
+				if (!window.confirm('Are you sure you want to close all active sessions?')) {
+					return
+				}
+				await check(e.target! as HTMLButtonElement, client.ProtocolSessionClose(0))
+				window.location.reload() // todo: reload less
+			}),
+		),
+		dom.br(),
+
+		dom.h2(_('Export')),
+		dom.p('Export messages of all mailboxes, or a selection of mailboxes and messages. The archive is downloaded while it is being created.'),
 		dom.form(
 			attr.target('_blank'), attr.method('POST'), attr.action('export'),
 			dom.input(attr.type('hidden'), attr.name('csrf'), attr.value(localStorageGet('webaccountcsrftoken') || '')),
@@ -1187,20 +1954,52 @@ const index = async () => {
 			dom.div(style({display: 'flex', flexDirection: 'column', gap: '.5ex'}),
 				dom.div(
 					dom.label(dom.input(attr.type('radio'), attr.name('format'), attr.value('maildir'), attr.checked('')), ' Maildir'), ' ',
-					dom.label(dom.input(attr.type('radio'), attr.name('format'), attr.value('mbox')), ' Mbox'),
+					dom.label(dom.input(attr.type('radio'), attr.name('format'), attr.value('mbox')), ' Mbox'), ' ',
+					dom.label(dom.input(attr.type('radio'), attr.name('format'), attr.value('eml')), ' EML'),
 				),
 				dom.div(
 					dom.label(dom.input(attr.type('radio'), attr.name('archive'), attr.value('tar')), ' Tar'), ' ',
 					dom.label(dom.input(attr.type('radio'), attr.name('archive'), attr.value('tgz'), attr.checked('')), ' Tgz'), ' ',
 					dom.label(dom.input(attr.type('radio'), attr.name('archive'), attr.value('zip')), ' Zip'), ' ',
 				),
+				dom.div(style({display: 'flex', gap: '1em', flexWrap: 'wrap', alignItems: 'flex-start'}),
+					dom.label(
+						dom.div('Only mailboxes', attr.title('Patterns for mailbox names, one per line, e.g. "Archive/20*". A pattern also matches child mailboxes. If empty, all mailboxes are exported.')),
+						dom.textarea(attr.name('include'), attr.rows('2')),
+					),
+					dom.label(
+						dom.div('Skip mailboxes', attr.title('Patterns for mailbox names not to export, one per line, e.g. "Junk". A pattern also matches child mailboxes.')),
+						dom.textarea(attr.name('exclude'), attr.rows('2')),
+					),
+					dom.label(dom.div('Received after'), dom.input(attr.type('date'), attr.name('after'))),
+					dom.label(dom.div('Received before'), dom.input(attr.type('date'), attr.name('before'))),
+					dom.label(
+						dom.div('With flags', attr.title('Only export messages with all these flags, space-separated, e.g. \\Seen, $Junk or custom keywords.')),
+						dom.input(attr.name('flags')),
+					),
+					dom.label(
+						dom.div('Without flags', attr.title('Only export messages without any of these flags, space-separated.')),
+						dom.input(attr.name('notflags')),
+					),
+				),
 				dom.div(style({marginTop: '1ex'}), dom.submitbutton('Export')),
 			),
 		),
 		dom.br(),
 
-		dom.h2('Import'),
-		dom.p('Import messages from a .zip or .tgz file with maildirs and/or mbox files.'),
+		dom.h2(_('Takeout')),
+		dom.p('Download all data of your account in a single archive: account configuration with addresses, settings, identities, filter rules, contacts, calendar events and all mailboxes with messages in maildir format.'),
+		dom.form(
+			attr.target('_blank'),
+			attr.method('POST'),
+			attr.action('takeout'),
+			dom.input(attr.type('hidden'), attr.name('csrf'), attr.value(localStorageGet('webaccountcsrftoken') || '')),
+			dom.submitbutton('Download takeout'),
+		),
+		dom.br(),
+
+		dom.h2(_('Import')),
+		dom.p('Import messages from a .zip or .tgz file with maildirs, mbox files and/or .eml files, or from an Outlook .pst file.'),
 		importForm=dom.form(
 			async function submit(e: SubmitEvent) {
 				e.preventDefault()
@@ -1259,7 +2058,7 @@ const index = async () => {
 						// Ignore error, could be some browser security thing like private browsing.
 					}
 
-					await importTrack(result.Token)
+					await importTrack(result.Token, false)
 				} catch (err) {
 					console.log({err})
 					window.alert('Error: ' + errmsg(err))
@@ -1276,7 +2075,7 @@ const index = async () => {
 							mailboxFileHint.style.display = ''
 						}),
 					),
-					mailboxFileHint=dom.p(style({display: 'none', fontStyle: 'italic', marginTop: '.5ex'}), 'This file must either be a zip file or a gzipped tar file with mbox and/or maildir mailboxes. For maildirs, an optional file "dovecot-keywords" is read additional keywords, like Forwarded/Junk/NotJunk. If an imported mailbox already exists by name, messages are added to the existing mailbox. If a mailbox does not yet exist it will be created.'),
+					mailboxFileHint=dom.p(style({display: 'none', fontStyle: 'italic', marginTop: '.5ex'}), 'This file must either be a zip file or a gzipped tar file with mbox and/or maildir mailboxes, or directories with .eml files, or an Outlook .pst file. For maildirs, an optional file "dovecot-keywords" is read additional keywords, like Forwarded/Junk/NotJunk. For directories with .eml files, an optional file "metadata.json", as written by an EML export, is read for flags and received times. If an imported mailbox already exists by name, messages are added to the existing mailbox. If a mailbox does not yet exist it will be created.'),
 				),
 				dom.div(
 					style({marginBottom: '1ex'}),
@@ -1294,20 +2093,106 @@ const index = async () => {
 				),
 			),
 		),
+		dom.br(),
+		dom.h3('Migrate from IMAP server'),
+		dom.p('Copy all mailboxes and messages, with their flags and receive times, from an account at another IMAP server. The account at the other server is not changed. Running the migration again later adds new messages and updates flags of migrated messages, so you can keep the accounts in sync until you switch over. Messages removed at the other server after migrating are kept.'),
+		dom.form(
+			async function submit(e: SubmitEvent) {
+				e.preventDefault()
+				e.stopPropagation()
+
+				migrateFieldset.disabled = true
+				importFieldset.disabled = true
+				try {
+					const result = await client.ImportIMAP(migrateHost.value, migrateStartTLS.checked ? 'starttls' : 'tls', migrateUsername.value, migratePassword.value, migratePrefix.value)
+					migratePassword.value = ''
+					try {
+						window.sessionStorage.setItem('ImportToken', result.Token)
+						window.sessionStorage.setItem('ImportMigration', 'yes')
+					} catch (err) {
+						console.log('storing import token in session storage', {err})
+						// Ignore error, could be some browser security thing like private browsing.
+					}
+					dom._kids(importProgress)
+					importProgress.style.display = ''
+					await importTrack(result.Token, true)
+				} catch (err) {
+					console.log({err})
+					window.alert('Error: ' + errmsg(err))
+				} finally {
+					migrateFieldset.disabled = false
+					importFieldset.disabled = false
+				}
+			},
+			migrateFieldset=dom.fieldset(
+				dom.div(
+					style({display: 'flex', gap: '1em', flexWrap: 'wrap', marginBottom: '1ex'}),
+					dom.label(
+						dom.div(style({marginBottom: '.5ex'}), 'Host', attr.title('Host name of the IMAP server, with optional port. The default port is 993, or 143 with STARTTLS.')),
+						migrateHost=dom.input(attr.required(''), attr.placeholder('imap.example.com')),
+					),
+					dom.label(
+						dom.div(style({marginBottom: '.5ex'}), 'Username'),
+						migrateUsername=dom.input(attr.required(''), attr.autocomplete('off')),
+					),
+					dom.label(
+						dom.div(style({marginBottom: '.5ex'}), 'Password'),
+						migratePassword=dom.input(attr.type('password'), attr.required(''), attr.autocomplete('off')),
+					),
+					dom.label(
+						dom.div(style({marginBottom: '.5ex'}), 'Mailbox prefix (optional)', attr.title('If set, mailboxes are migrated as child mailboxes of this mailbox. Otherwise, mailboxes like Sent and Trash are migrated into the local mailboxes with the same role.')),
+						migratePrefix=dom.input(),
+					),
+				),
+				dom.div(
+					style({marginBottom: '1ex'}),
+					dom.label(migrateStartTLS=dom.input(attr.type('checkbox')), ' Use STARTTLS instead of TLS', attr.title('Connect without TLS, typically to port 143, and then switch to TLS with STARTTLS.')),
+				),
+				dom.div(dom.submitbutton('Migrate')),
+			),
+		),
 		importAbortBox=dom.div(), // Outside fieldset because it gets disabled, above progress because may be scrolling it down quickly with problems.
 		importProgress=dom.div(
 			style({display: 'none'}),
 		),
 		dom.br(),
 
+		dom.h2(_('Delete account')),
+		dom.p('Deleting your account disables logins immediately. Your account and all its data, including messages, are removed after 30 days. Until then, messages for your addresses are still accepted, and an admin can cancel the deletion. Download a takeout archive first if you want to keep your data.'),
+		dom.form(
+			deleteFieldset=dom.fieldset(
+				dom.label(
+					style({display: 'inline-block'}),
+					'Current password',
+					dom.br(),
+					deletePassword=dom.input(attr.type('password'), attr.autocomplete('current-password'), attr.required('')),
+				),
+				' ',
+				dom.submitbutton('Delete account'),
+			),
+			async function submit(e: SubmitEvent) {
+				e.stopPropagation()
+				e.preventDefault()
+				if (!window.confirm('Are you sure you want to delete your account? You will be logged out and can no longer log in.')) {
+					return
+				}
+				const purgeAfter = await check(deleteFieldset, client.AccountDelete(deletePassword.value))
+				window.alert('Account scheduled for deletion, it will be removed after ' + purgeAfter.toLocaleString() + '.')
+				window.location.reload()
+			},
+		),
+		dom.br(),
+
 		footer,
 	)
 
 	// Try to show the progress of an earlier import session. The user may have just
 	// refreshed the browser.
 	let importToken: string
+	let importMigration: boolean
 	try {
 		importToken = window.sessionStorage.getItem('ImportToken') || ''
+		importMigration = !!window.sessionStorage.getItem('ImportMigration')
 	} catch (err) {
 		console.log('looking up ImportToken in session storage', {err})
 		return
@@ -1316,22 +2201,25 @@ const index = async () => {
 		return
 	}
 	importFieldset.disabled = true
+	migrateFieldset.disabled = true
 	dom._kids(importProgress,
 		dom.div(
 			dom.div('Reconnecting to import...'),
 		),
 	)
 	importProgress.style.display = ''
-	importTrack(importToken)
+	importTrack(importToken, importMigration)
 	.catch(() => {
 		if (window.confirm('Error reconnecting to import. Remove this import session?')) {
 			window.sessionStorage.removeItem('ImportToken')
+			window.sessionStorage.removeItem('ImportMigration')
 			dom._kids(importProgress)
 			importProgress.style.display = 'none'
 		}
 	})
 	.finally(() => {
 		importFieldset.disabled = false
+		migrateFieldset.disabled = false
 	})
 }
 
@@ -1554,7 +2442,7 @@ const destination = async (name: string) => {
 		dom.br(),
 		dom.br(),
 		dom.br(),
-		dom.p("Apple's mail applications don't do account autoconfiguration, and when adding an account it can choose defaults that don't work with modern email servers. Adding an account through a \"mobileconfig\" profile file can be more convenient: It contains the IMAP/SMTP settings such as host name, port, TLS, authentication mechanism and user name. This profile does not contain a login password. Opening the profile adds it under Profiles in System Preferences (macOS) or Settings (iOS), where you can install it. These profiles are not signed, so users will have to ignore the warnings about them being unsigned. ",
+		dom.p("Apple's mail applications don't do account autoconfiguration, and when adding an account it can choose defaults that don't work with modern email servers. Adding an account through a \"mobileconfig\" profile file can be more convenient: It contains the IMAP/SMTP settings such as host name, port, TLS, authentication mechanism and user name. This profile does not contain a login password. Opening the profile adds it under Profiles in System Preferences (macOS) or Settings (iOS), where you can install it. Profiles are signed with the TLS certificate of the autoconfig host name, so they are shown as verified. ",
 			dom.br(),
 			dom.a(attr.href('https://autoconfig.'+domainName(acc.DNSDomain)+'/profile.mobileconfig?addresses='+encodeURIComponent(addresses.join(','))+'&name='+encodeURIComponent(dest.FullName)), attr.download(''), 'Download .mobileconfig email account profile'),
 			dom.br(),
@@ -1563,7 +2451,296 @@ const destination = async (name: string) => {
 	)
 }
 
+const clientSettings = async (address: string) => {
+	const cs = await client.ClientSettings(address)
+
+	const security = (s: string) => ({tls: 'SSL/TLS', starttls: 'STARTTLS', none: 'None'} as {[k: string]: string})[s] || s
+	let jsonURL = ''
+	if (cs.Autoconfig) {
+		const u = new URL(cs.Autoconfig)
+		u.pathname = '/client-settings.json'
+		jsonURL = u.toString()
+	}
+
+	dom._kids(page,
+		crumbs(
+			crumblink('Mox Account', '#'),
+			'Client settings for '+address,
+		),
+		dom.p('Settings for configuring email, calendar and contacts applications. Log in with email address ', dom.b(prewrap(cs.Address)), ' and your password.', jsonURL ? [' The settings are also available ', dom.a(attr.href(jsonURL), 'as JSON'), ', for integrators.'] : []),
+		dom.table(
+			dom.thead(
+				dom.tr(
+					dom.th('Service'),
+					dom.th('Host'),
+					dom.th('Port'),
+					dom.th('Security'),
+				),
+			),
+			dom.tbody(
+				dom.tr(dom.td('IMAP (incoming)'), dom.td(cs.IMAP.Host), dom.td(''+cs.IMAP.Port), dom.td(security(cs.IMAP.Security))),
+				dom.tr(dom.td('SMTP (outgoing)'), dom.td(cs.Submission.Host), dom.td(''+cs.Submission.Port), dom.td(security(cs.Submission.Security))),
+			),
+		),
+		dom.br(),
+		dom.table(
+			dom.thead(
+				dom.tr(
+					dom.th('Service'),
+					dom.th('URL'),
+				),
+			),
+			dom.tbody(
+				([
+					['CalDAV/CardDAV', cs.DAV],
+					['Webmail', cs.Webmail],
+					['Account', cs.Account],
+					['WebAPI', cs.WebAPI],
+					['Autoconfig', cs.Autoconfig],
+					['Autodiscover', cs.Autodiscover],
+					['Apple profile', cs.MobileConfig],
+				] as [string, string][]).filter(t => t[1]).map(t =>
+					dom.tr(dom.td(t[0]), dom.td(dom.a(attr.href(t[1]), t[1]))),
+				),
+			),
+		),
+		(cs.Clients || []).map(c => [
+			dom.br(),
+			dom.h2(c.Client),
+			dom.ol((c.Steps || []).map(s => dom.li(s))),
+		]),
+	)
+}
+
+const quarantine = async () => {
+	const messages = await client.Quarantined() || []
+
+	dom._kids(page,
+		crumbs(
+			crumblink('Mox Account', '#'),
+			'Quarantine',
+		),
+		dom.p('Incoming messages held in quarantine because they look suspicious, e.g. like spam or malware. Release a message to deliver it to your mailbox. Messages are removed automatically when they expire.'),
+		dom.table(
+			dom.thead(
+				dom.tr(
+					dom.th('Received'),
+					dom.th('From'),
+					dom.th('To'),
+					dom.th('Subject'),
+					dom.th('Reason'),
+					dom.th('Expires'),
+					dom.th('Action'),
+				),
+			),
+			dom.tbody(
+				messages.length === 0 ? dom.tr(dom.td(attr.colspan('7'), '(None)')) : [],
+				messages.map(qm =>
+					dom.tr(
+						dom.td(age(qm.Received)),
+						dom.td(qm.MsgFrom || qm.MailFrom),
+						dom.td(qm.RcptTo),
+						dom.td(qm.Subject),
+						dom.td(qm.Reason),
+						dom.td(qm.Expires.toLocaleString()),
+						dom.td(
+							dom.clickbutton('Headers', async function click(e: MouseEvent) {
+								const headers = await check(e.target! as HTMLButtonElement, client.QuarantineHeaders(qm.ID))
+								popup(
+									dom.h1('Headers'),
+									dom.pre(style({maxWidth: '80em', whiteSpace: 'pre-wrap'}), headers),
+								)
+							}), ' ',
+							dom.clickbutton('Release', async function click(e: MouseEvent) {
+								await check(e.target! as HTMLButtonElement, client.QuarantineRelease(qm.ID))
+								window.location.reload() // todo: reload less
+							}), ' ',
+							dom.clickbutton('Remove', async function click(e: MouseEvent) {
+								if (!window.confirm('Are you sure you want to remove this message?')) {
+									return
+								}
+								await check(e.target! as HTMLButtonElement, client.QuarantineRemove(qm.ID))
+								window.location.reload() // todo: reload less
+							}),
+						),
+					),
+				),
+			),
+		),
+	)
+}
+
+const contacts = async () => {
+	const l = await client.Contacts() || []
+
+	let editing: api.Contact | null = null
+	let fieldset: HTMLFieldSetElement
+	let name: HTMLInputElement
+	let emails: HTMLTextAreaElement
+	let phones: HTMLTextAreaElement
+	let organization: HTMLInputElement
+	let notes: HTMLTextAreaElement
+	let formTitle: HTMLElement
+	let importFile: HTMLInputElement
+
+	const split = (s: string) => s.split(/[\n,]+/).map(s => s.trim()).filter(s => s)
+
+	const edit = (c: api.Contact | null) => {
+		editing = c
+		formTitle.textContent = c ? 'Edit contact' : 'Add contact'
+		name.value = c?.Name || ''
+		emails.value = (c?.Emails || []).join('\n')
+		phones.value = (c?.Phones || []).join('\n')
+		organization.value = c?.Organization || ''
+		notes.value = c?.Notes || ''
+	}
+
+	dom._kids(page,
+		crumbs(
+			crumblink('Mox Account', '#'),
+			'Contacts',
+		),
+		dom.p('Contacts are used for completing recipient addresses in webmail. Recipients of messages you send are added automatically, marked as harvested. Phones and desktop clients can synchronize the address book with CardDAV, logging in with your email address and password.'),
+		dom.table(
+			dom.thead(
+				dom.tr(
+					dom.th('Name'),
+					dom.th('Email addresses'),
+					dom.th('Phone numbers'),
+					dom.th('Organization'),
+					dom.th('Harvested', attr.title('Added automatically as recipient of a sent message.')),
+					dom.th('Action'),
+				),
+			),
+			dom.tbody(
+				l.length === 0 ? dom.tr(dom.td(attr.colspan('6'), '(None)')) : [],
+				l.map(c =>
+					dom.tr(
+						dom.td(c.Name),
+						dom.td((c.Emails || []).join(', ')),
+						dom.td((c.Phones || []).join(', ')),
+						dom.td(c.Organization),
+						dom.td(c.Harvested ? 'Yes' : ''),
+						dom.td(
+							dom.clickbutton('Edit', function click() {
+								edit(c)
+								name.focus()
+							}), ' ',
+							dom.clickbutton('Remove', async function click(e: MouseEvent) {
+								if (!window.confirm('Are you sure you want to remove this contact?')) {
+									return
+								}
+								await check(e.target! as HTMLButtonElement, client.ContactRemove(c.ID))
+								window.location.reload() // todo: reload less
+							}),
+						),
+					),
+				),
+			),
+		),
+		dom.br(),
+
+		formTitle=dom.h2('Add contact'),
+		dom.form(
+			async function submit(e: SubmitEvent) {
+				e.preventDefault()
+				e.stopPropagation()
+
+				const c: api.Contact = {
+					ID: 0,
+					UID: '',
+					Href: '',
+					Created: new Date(),
+					Updated: new Date(),
+					Harvested: false,
+					...(editing || {}),
+					Name: name.value,
+					Emails: split(emails.value),
+					Phones: split(phones.value),
+					Organization: organization.value,
+					Notes: notes.value,
+				}
+				await check(fieldset, client.ContactSave(c))
+				window.location.reload() // todo: reload less
+			},
+			fieldset=dom.fieldset(
+				dom.div(style({display: 'flex', gap: '1em', flexWrap: 'wrap', alignItems: 'flex-start'}),
+					dom.label(
+						style({display: 'inline-block'}),
+						'Name',
+						dom.br(),
+						name=dom.input(),
+					),
+					dom.label(
+						style({display: 'inline-block'}),
+						dom.span('Email addresses', attr.title('One per line.')),
+						dom.br(),
+						emails=dom.textarea(attr.rows('3')),
+					),
+					dom.label(
+						style({display: 'inline-block'}),
+						dom.span('Phone numbers', attr.title('One per line.')),
+						dom.br(),
+						phones=dom.textarea(attr.rows('3')),
+					),
+					dom.label(
+						style({display: 'inline-block'}),
+						'Organization',
+						dom.br(),
+						organization=dom.input(),
+					),
+					dom.label(
+						style({display: 'inline-block'}),
+						'Notes',
+						dom.br(),
+						notes=dom.textarea(attr.rows('3')),
+					),
+				),
+				dom.div(
+					style({marginTop: '1ex'}),
+					dom.submitbutton('Save'), ' ',
+					dom.clickbutton('Cancel', function click() {
+						edit(null)
+					}),
+				),
+			),
+		),
+		dom.br(),
+
+		dom.h2('Import and export'),
+		dom.p('Import contacts from a vCard file (.vcf), e.g. exported from another address book. Contacts with the same UID are updated.'),
+		dom.form(
+			async function submit(e: SubmitEvent) {
+				e.preventDefault()
+				e.stopPropagation()
+
+				const f = (importFile.files || [])[0]
+				if (!f) {
+					return
+				}
+				const vcards = await f.text()
+				const n = await check(e.target! as HTMLButtonElement, client.ContactsImport(vcards))
+				window.alert('Imported ' + n + ' contact(s).')
+				window.location.reload() // todo: reload less
+			},
+			importFile=dom.input(attr.type('file'), attr.required('')),
+			' ',
+			dom.submitbutton('Import'),
+		),
+		dom.br(),
+		dom.clickbutton('Export all contacts', async function click(e: MouseEvent) {
+			const vcards = await check(e.target! as HTMLButtonElement, client.ContactsExport())
+			const a = dom.a(attr.href(URL.createObjectURL(new Blob([vcards], {type: 'text/vcard'}))), attr.download('contacts.vcf'))
+			a.click()
+			setTimeout(() => URL.revokeObjectURL(a.href), 1000)
+		}),
+	)
+}
+
 const init = async () => {
+	await i18nLoad()
+	await oidcLoginFinish()
+
 	let curhash: string | undefined
 
 	const hashChange = async () => {
@@ -1581,6 +2758,12 @@ const init = async () => {
 				await index()
 			} else if (t[0] === 'destinations' && t.length === 2) {
 				await destination(t[1])
+			} else if (t[0] === 'clientsettings' && t.length === 2) {
+				await clientSettings(t[1])
+			} else if (h === 'contacts') {
+				await contacts()
+			} else if (h === 'quarantine') {
+				await quarantine()
 			} else {
 				dom._kids(page, 'page not found')
 			}
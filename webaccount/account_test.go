@@ -6,7 +6,12 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/ecdh"
+	cryptorand "crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
@@ -32,6 +37,7 @@ import (
 	"github.com/mjl-/mox/mox-"
 	"github.com/mjl-/mox/queue"
 	"github.com/mjl-/mox/store"
+	"github.com/mjl-/mox/totp"
 	"github.com/mjl-/mox/webauth"
 	"github.com/mjl-/mox/webhook"
 )
@@ -113,14 +119,14 @@ func TestAccount(t *testing.T) {
 	ctx := context.WithValue(ctxbg, requestInfoCtxKey, reqInfo)
 
 	// Missing login token.
-	tneedErrorCode(t, "user:error", func() { api.Login(ctx, "", "mjl☺@mox.example", "test1234") })
+	tneedErrorCode(t, "user:error", func() { api.Login(ctx, "", "mjl☺@mox.example", "test1234", "") })
 
 	// Login with loginToken.
 	loginCookie := &http.Cookie{Name: "webaccountlogin"}
 	loginCookie.Value = api.LoginPrep(ctx)
 	reqInfo.Request.Header = http.Header{"Cookie": []string{loginCookie.String()}}
 
-	csrfToken := api.Login(ctx, loginCookie.Value, "mjl☺@mox.example", "test1234")
+	csrfToken := api.Login(ctx, loginCookie.Value, "mjl☺@mox.example", "test1234", "")
 	var sessionCookie *http.Cookie
 	for _, c := range respRec.Result().Cookies() {
 		if c.Name == "webaccountsession" {
@@ -135,9 +141,9 @@ func TestAccount(t *testing.T) {
 	// Valid loginToken, but bad credentials.
 	loginCookie.Value = api.LoginPrep(ctx)
 	reqInfo.Request.Header = http.Header{"Cookie": []string{loginCookie.String()}}
-	tneedErrorCode(t, "user:loginFailed", func() { api.Login(ctx, loginCookie.Value, "mjl☺@mox.example", "badauth") })
-	tneedErrorCode(t, "user:loginFailed", func() { api.Login(ctx, loginCookie.Value, "baduser@mox.example", "badauth") })
-	tneedErrorCode(t, "user:loginFailed", func() { api.Login(ctx, loginCookie.Value, "baduser@baddomain.example", "badauth") })
+	tneedErrorCode(t, "user:loginFailed", func() { api.Login(ctx, loginCookie.Value, "mjl☺@mox.example", "badauth", "") })
+	tneedErrorCode(t, "user:loginFailed", func() { api.Login(ctx, loginCookie.Value, "baduser@mox.example", "badauth", "") })
+	tneedErrorCode(t, "user:loginFailed", func() { api.Login(ctx, loginCookie.Value, "baduser@baddomain.example", "badauth", "") })
 
 	type httpHeaders [][2]string
 	ctJSON := [2]string{"Content-Type", "application/json; charset=utf-8"}
@@ -226,6 +232,11 @@ func TestAccount(t *testing.T) {
 	ctx = context.WithValue(ctxbg, requestInfoCtxKey, reqInfo)
 
 	api.SetPassword(ctx, "test1234")
+	tneedErrorCode(t, "user:error", func() { api.SetPassword(ctx, "short") })
+
+	changeRequired, minLength, _, _ := api.PasswordStatus(ctx)
+	tcompare(t, changeRequired, false)
+	tcompare(t, minLength, 8)
 
 	err = queue.Init() // For DB.
 	tcheck(t, err, "queue init")
@@ -248,6 +259,138 @@ func TestAccount(t *testing.T) {
 	api.AccountSaveFullName(ctx, account.FullName+" changed") // todo: check if value was changed
 	api.AccountSaveFullName(ctx, account.FullName)
 
+	api.LanguageSave(ctx, "nl")
+	tcompare(t, api.Language(ctx), "nl")
+	tneedErrorCode(t, "user:error", func() { api.LanguageSave(ctx, "xx") })
+	api.LanguageSave(ctx, "")
+
+	// Push subscriptions, added through webmail.
+	tcompare(t, len(api.PushSubscriptions(ctx)), 0)
+	pushPriv, err := ecdh.P256().GenerateKey(cryptorand.Reader)
+	tcheck(t, err, "generate key")
+	ps := store.PushSubscription{
+		Endpoint: "https://localhost:1/push", // Nothing listening.
+		P256DH:   base64.RawURLEncoding.EncodeToString(pushPriv.PublicKey().Bytes()),
+		Auth:     base64.RawURLEncoding.EncodeToString(make([]byte, 16)),
+		Label:    "test",
+	}
+	err = acc.PushSubscriptionAdd(ctx, &ps)
+	tcheck(t, err, "add push subscription")
+	tneedErrorCode(t, "user:error", func() { api.PushSubscriptionTest(ctx, ps.ID) })
+	pushSubs := api.PushSubscriptions(ctx)
+	tcompare(t, len(pushSubs), 1)
+	tcompare(t, pushSubs[0].LastError != "", true)
+	api.PushSubscriptionRemove(ctx, ps.ID)
+	tneedErrorCode(t, "user:error", func() { api.PushSubscriptionRemove(ctx, ps.ID) })
+	tcompare(t, len(api.PushSubscriptions(ctx)), 0)
+
+	// Senders for which remote content is loaded.
+	tcompare(t, len(api.RemoteContentSenders(ctx)), 0)
+	api.RemoteContentSenderAdd(ctx, "News@Example.com")
+	api.RemoteContentSenderAdd(ctx, "@Example.ORG")
+	tneedErrorCode(t, "user:error", func() { api.RemoteContentSenderAdd(ctx, "bogus") })
+	tcompare(t, api.RemoteContentSenders(ctx), []string{"@example.org", "News@example.com"})
+	api.RemoteContentSenderRemove(ctx, "@example.org")
+	tneedErrorCode(t, "user:error", func() { api.RemoteContentSenderRemove(ctx, "@example.org") })
+	api.RemoteContentSenderRemove(ctx, "News@example.com")
+
+	// Allow/deny sender lists.
+	tcompare(t, len(api.SenderList(ctx)), 0)
+	sle := api.SenderListSet(ctx, "@Example.ORG", store.SenderAllow)
+	tcompare(t, sle.Sender, "@example.org")
+	api.SenderListSet(ctx, "@example.org", store.SenderJunk)
+	tneedErrorCode(t, "user:error", func() { api.SenderListSet(ctx, "bogus", store.SenderReject) })
+	tneedErrorCode(t, "user:error", func() { api.SenderListSet(ctx, "spam@example.com", "bogus") })
+	senders := api.SenderList(ctx)
+	tcompare(t, len(senders), 1)
+	tcompare(t, senders[0].Action, store.SenderJunk)
+	api.SenderListRemove(ctx, sle.ID)
+	tneedErrorCode(t, "user:error", func() { api.SenderListRemove(ctx, sle.ID) })
+
+	// Aliases created by the account itself, within the configured limit.
+	tneedErrorCode(t, "user:error", func() { api.AliasCreate(ctx, "list", "mox.example", "mjl☺@mox.example", true) })
+	err = mox.AccountSave(ctx, "mjl☺", func(acc *config.Account) { acc.MaxAliases = 1 })
+	tcheck(t, err, "set max aliases")
+	tneedErrorCode(t, "user:error", func() { api.AliasCreate(ctx, "list", "other.example", "mjl☺@mox.example", true) })
+	tneedErrorCode(t, "user:error", func() { api.AliasCreate(ctx, "list", "mox.example", "bogus@mox.example", true) })
+	tneedErrorCode(t, "user:error", func() { api.AliasCreate(ctx, "other", "mox.example", "mjl☺@mox.example", true) })
+	tneedErrorCode(t, "user:error", func() { api.AliasCreate(ctx, "list+x", "mox.example", "mjl☺@mox.example", true) })
+	api.AliasCreate(ctx, "list", "mox.example", "other@mox.example", true)
+	owned, max := api.AliasesOwned(ctx)
+	tcompare(t, owned, []string{"list@mox.example"})
+	tcompare(t, max, 1)
+	tneedErrorCode(t, "user:error", func() { api.AliasCreate(ctx, "list2", "mox.example", "mjl☺@mox.example", true) })
+	tneedErrorCode(t, "user:error", func() { api.AliasDelete(ctx, "support@mox.example") })
+	api.AliasDelete(ctx, "list@mox.example")
+	err = mox.AccountSave(ctx, "mjl☺", func(acc *config.Account) { acc.MaxAliases = 0 })
+	tcheck(t, err, "restore max aliases")
+
+	// Sending identities.
+	tcompare(t, len(api.Identities(ctx)), 0)
+	ident := api.IdentitySave(ctx, store.Identity{Address: "mjl☺@mox.example", FullName: " Mjl ", ReplyTo: "replies@example.com", Signature: "-- \nmjl"})
+	tcompare(t, ident.FullName, "Mjl")
+	api.IdentitySave(ctx, store.Identity{Address: "support@mox.example", FullName: "Support"})
+	tneedErrorCode(t, "user:error", func() { api.IdentitySave(ctx, store.Identity{Address: "bogus@mox.example"}) })
+	tneedErrorCode(t, "user:error", func() { api.IdentitySave(ctx, store.Identity{Address: "other@mox.example", ReplyTo: "bogus"}) })
+	ident.FullName = "Mjl 2"
+	ident = api.IdentitySave(ctx, ident)
+	identities := api.Identities(ctx)
+	tcompare(t, len(identities), 2)
+	tcompare(t, identities[0], ident)
+	api.IdentityRemove(ctx, ident.ID)
+	tneedErrorCode(t, "user:error", func() { api.IdentityRemove(ctx, ident.ID) })
+	tcompare(t, len(api.Identities(ctx)), 1)
+
+	// Two-factor authentication.
+	totpSecret, _, _ := api.TOTPSetup(ctx)
+	secretBuf, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(totpSecret)
+	tcheck(t, err, "decode totp secret")
+	counter := totp.Counter(time.Now())
+	tneedErrorCode(t, "user:error", func() { api.TOTPEnable(ctx, "000000") })
+	recoveryCodes := api.TOTPEnable(ctx, totp.Code(secretBuf, counter-1))
+	tcompare(t, len(recoveryCodes), 10)
+	enabled, required, left := api.TOTPStatus(ctx)
+	tcompare(t, enabled, true)
+	tcompare(t, required, false)
+	tcompare(t, left, 10)
+
+	loginReqInfo := requestInfo{"", "", "", httptest.NewRecorder(), &http.Request{RemoteAddr: "127.0.0.1:1234"}}
+	loginctx := context.WithValue(ctxbg, requestInfoCtxKey, loginReqInfo)
+	passwordTOTPLogin := func(password, code, expErrCode string) {
+		t.Helper()
+		loginCookie.Value = api.LoginPrep(loginctx)
+		loginReqInfo.Request.Header = http.Header{"Cookie": []string{loginCookie.String()}}
+		if expErrCode != "" {
+			tneedErrorCode(t, expErrCode, func() { api.Login(loginctx, loginCookie.Value, "mjl☺@mox.example", password, code) })
+		} else {
+			api.Login(loginctx, loginCookie.Value, "mjl☺@mox.example", password, code)
+		}
+	}
+	totpLogin := func(code, expErrCode string) {
+		t.Helper()
+		passwordTOTPLogin("test1234", code, expErrCode)
+	}
+	totpLogin("", "user:totpRequired")
+	totpLogin("000000", "user:loginFailed")
+	totpLogin(totp.Code(secretBuf, counter), "")
+	totpLogin(recoveryCodes[0], "")
+	totpLogin(recoveryCodes[0], "user:loginFailed") // Already used.
+	totpLogin("0123456789", "user:loginFailed")     // Not a recovery code.
+	// A wrong password doesn't use up the recovery code.
+	passwordTOTPLogin("bogus", recoveryCodes[2], "user:loginFailed")
+	_, _, left = api.TOTPStatus(ctx)
+	tcompare(t, left, 9)
+	// Recovery codes can be entered with spaces and in upper case.
+	totpLogin(strings.ToUpper(recoveryCodes[2][:5]+" "+recoveryCodes[2][5:]), "")
+	_, _, left = api.TOTPStatus(ctx)
+	tcompare(t, left, 8)
+
+	tneedErrorCode(t, "user:error", func() { api.TOTPDisable(ctx, "000000") })
+	api.TOTPDisable(ctx, recoveryCodes[1])
+	enabled, _, _ = api.TOTPStatus(ctx)
+	tcompare(t, enabled, false)
+	totpLogin("", "")
+
 	go ImportManage()
 
 	// Import mbox/maildir tgz/zip.
@@ -348,7 +491,8 @@ func TestAccount(t *testing.T) {
 		return nil
 	})
 
-	testExport := func(format, archive string, expectFiles int) {
+	// Filter has key/value pairs with additional form fields.
+	testExport := func(format, archive string, expectFiles int, filter ...string) {
 		t.Helper()
 
 		fields := url.Values{
@@ -358,6 +502,9 @@ func TestAccount(t *testing.T) {
 			"mailbox":   []string{""},
 			"recursive": []string{"on"},
 		}
+		for i := 0; i+1 < len(filter); i += 2 {
+			fields.Set(filter[i], filter[i+1])
+		}
 		r := httptest.NewRequest("POST", "/export", strings.NewReader(fields.Encode()))
 		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 		r.Header.Add("Cookie", cookieOK.String())
@@ -406,6 +553,31 @@ func TestAccount(t *testing.T) {
 	testExport("maildir", "zip", 6)
 	testExport("mbox", "tar", 2+6) // 2 imported plus 6 default mailboxes (Inbox, Draft, etc)
 	testExport("mbox", "zip", 2+6)
+	testExport("eml", "zip", 4+8) // 4 messages, and a metadata file for each of the 8 mailboxes
+	testExport("mbox", "tgz", 2, "include", "importtest\nmaildir")
+	testExport("maildir", "tar", 2+1, "include", "importtest\nmaildir", "flags", "custom") // 2 messages and a dovecot-keywords file
+	testExport("eml", "tar", 0+6, "exclude", "importtest\nmaildir")
+	testExport("eml", "tar", 0+8, "after", "2100-01-01")
+
+	testImport(filepath.FromSlash("../testdata/importtest.eml.zip"), 2)
+	testImport(filepath.FromSlash("../testdata/importtest.pst"), 2)
+
+	// Check flags and received time from eml metadata, and mailboxes for pst folders.
+	acc.DB.Read(ctxbg, func(tx *bstore.Tx) error {
+		m, err := bstore.QueryTx[store.Message](tx).FilterEqual("Expunged", false).FilterIn("Keywords", "emlkw").Get()
+		tcheck(t, err, `fetching message with keyword "emlkw"`)
+		tcompare(t, m.Seen, true)
+		tcompare(t, m.Received.Equal(time.Date(2022, 3, 4, 5, 6, 7, 0, time.UTC)), true)
+
+		for _, name := range []string{"emltest/sub", "Inbox/Sub"} {
+			mb, err := acc.MailboxFind(tx, name)
+			tcheck(t, err, "looking up mailbox")
+			if mb == nil {
+				t.Fatalf("missing mailbox %s", name)
+			}
+		}
+		return nil
+	})
 
 	sl := api.SuppressionList(ctx)
 	tcompare(t, len(sl), 0)
@@ -421,6 +593,19 @@ func TestAccount(t *testing.T) {
 	tneedErrorCode(t, "user:error", func() { api.SuppressionRemove(ctx, "mjl@mox.example") }) // Absent.
 	tneedErrorCode(t, "user:error", func() { api.SuppressionRemove(ctx, "bogus") })           // Not an address.
 
+	// Contacts, with import and export.
+	contact := api.ContactSave(ctx, store.Contact{Name: "Mox Test", Emails: []string{"test@mox.example"}})
+	tneedErrorCode(t, "user:error", func() { api.ContactSave(ctx, store.Contact{Emails: []string{"bogus"}}) })
+	tneedErrorCode(t, "user:error", func() { api.ContactSave(ctx, store.Contact{ID: contact.ID + 1000, Name: "x"}) })
+	vcards := api.ContactsExport(ctx)
+	api.ContactRemove(ctx, contact.ID)
+	tneedErrorCode(t, "user:error", func() { api.ContactRemove(ctx, contact.ID) })
+	tcompare(t, api.ContactsImport(ctx, vcards), 1)
+	tneedErrorCode(t, "user:error", func() { api.ContactsImport(ctx, "bogus") })
+	contacts := api.Contacts(ctx)
+	tcompare(t, len(contacts), 1)
+	tcompare(t, contacts[0].Emails, []string{"test@mox.example"})
+
 	var hooks int
 	hookServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintln(w, "ok")
@@ -470,3 +655,12 @@ func TestAccount(t *testing.T) {
 	api.Logout(ctx)
 	tneedErrorCode(t, "server:error", func() { api.Logout(ctx) })
 }
+
+func TestImportIMAPDial(t *testing.T) {
+	for _, addr := range []string{"127.0.0.1:993", "[::1]:993", "10.0.0.1:143", "169.254.169.254:993"} {
+		_, err := importIMAPDial(context.Background(), addr)
+		if !errors.Is(err, mox.ErrSpecialPurposeIP) {
+			t.Fatalf("dial %s: got err %v, expected ErrSpecialPurposeIP", addr, err)
+		}
+	}
+}
@@ -2,6 +2,24 @@
 
 namespace api {
 
+// PasskeyRequestOptions are the parameters for logging in with a passkey with
+// navigator.credentials.get in the browser.
+export interface PasskeyRequestOptions {
+	Challenge: string
+	RPID: string
+	Timeout: number  // In milliseconds.
+}
+
+// PasskeyAssertion is the response from navigator.credentials.get, with
+// base64url encoded values.
+export interface PasskeyAssertion {
+	CredentialID: string
+	ClientDataJSON: string
+	AuthenticatorData: string
+	Signature: string
+	UserHandle: string  // User ID from registration, identifying the account.
+}
+
 export interface Account {
 	OutgoingWebhook?: OutgoingWebhook | null
 	IncomingWebhook?: IncomingWebhook | null
@@ -14,14 +32,20 @@ export interface Account {
 	Destinations?: { [key: string]: Destination }
 	SubjectPass: SubjectPass
 	QuotaMessageSize: number
+	CompressMessages: boolean
 	RejectsMailbox: string
 	KeepRejects: boolean
 	AutomaticJunkFlags: AutomaticJunkFlags
 	JunkFilter?: JunkFilter | null  // todo: sane defaults for junkfilter
 	MaxOutgoingMessagesPerDay: number
 	MaxFirstTimeRecipientsPerDay: number
+	MaxAliases: number
 	NoFirstTimeSenderDelay: boolean
+	RequireTOTP: boolean
+	LoginDisabled: string
 	Routes?: Route[] | null
+	Footer?: Footer | null
+	PasswordPolicy?: PasswordPolicy | null
 	DNSDomain: Domain  // Parsed form of Domain.
 	Aliases?: AddressAlias[] | null
 }
@@ -79,6 +103,9 @@ export interface AutomaticJunkFlags {
 
 export interface JunkFilter {
 	Threshold: number
+	SharedWeight: number
+	ContributeShared: boolean
+	DelayFlagTraining: boolean
 	Onegrams: boolean
 	Twograms: boolean
 	Threegrams: boolean
@@ -97,6 +124,22 @@ export interface Route {
 	ToDomainASCII?: string[] | null
 }
 
+export interface Footer {
+	Text?: string[] | null
+	HTML?: string[] | null
+	SkipReplies: boolean
+}
+
+// PasswordPolicy holds requirements for new passwords.
+export interface PasswordPolicy {
+	MinLength: number
+	MinEntropy: number
+	DenyList?: string[] | null
+	DenyListFile: string
+	BreachCheck: boolean
+	BreachCheckURL: string
+}
+
 export interface AddressAlias {
 	SubscriptionAddress: string
 	Alias: Alias  // Without members.
@@ -108,9 +151,14 @@ export interface Alias {
 	PostPublic: boolean
 	ListMembers: boolean
 	AllowMsgFrom: boolean
+	Owner: string
+	OwnerAddress: string
+	Moderated: boolean
 	LocalpartStr: string  // In encoded form.
 	Domain: Domain
-	ParsedAddresses?: AliasAddress[] | null  // Matches addresses.
+	ParsedAddresses?: AliasAddress[] | null  // Matches addresses of local members.
+	ExternalAddresses?: Address[] | null  // External members, in domains not configured.
+	ParsedOwner: AliasAddress  // Zero if OwnerAddress is empty.
 }
 
 export interface AliasAddress {
@@ -137,6 +185,36 @@ export interface Suppression {
 	Reason: string
 }
 
+// ClientSettings holds the settings for configuring email, calendar and contacts
+// applications for an email address, generated from the listener configuration,
+// with setup instructions for common applications.
+export interface ClientSettings {
+	Address: string  // Email address, also used as login name.
+	IMAP: ClientServer
+	Submission: ClientServer
+	Account: string  // URLs of web services and autoconfiguration. Empty if not enabled.
+	Webmail: string
+	WebAPI: string
+	DAV: string  // CalDAV and CardDAV.
+	Autoconfig: string  // Thunderbird autoconfig.
+	Autodiscover: string  // Microsoft autodiscover.
+	MobileConfig: string  // Apple device management profile.
+	Clients?: ClientInstructions[] | null
+}
+
+// ClientServer is a server that email applications connect to.
+export interface ClientServer {
+	Host: string  // ASCII hostname.
+	Port: number
+	Security: string  // "tls" for TLS from the start of the connection, "starttls", or "none".
+}
+
+// ClientInstructions are setup steps for an application.
+export interface ClientInstructions {
+	Client: string
+	Steps?: string[] | null
+}
+
 // ImportProgress is returned after uploading a file to import.
 export interface ImportProgress {
 	Token: string  // For fetching progress, or cancelling an import.
@@ -204,6 +282,144 @@ export interface IncomingMeta {
 	Automated: boolean  // Whether this message was automated and should not receive automated replies. E.g. out of office or mailing list messages.
 }
 
+// Quarantined is an incoming message held in quarantine instead of being
+// rejected. The message file is stored separately, see package quarantine.
+export interface Quarantined {
+	ID: number
+	Received: Date
+	Expires: Date
+	Account: string
+	Mailbox: string  // Mailbox to deliver to when released.
+	Reason: string  // Reason the message would have been rejected, e.g. "hash-blocklisted".
+	RemoteIP: string
+	MailFrom: string  // SMTP MAIL FROM, empty for the null sender.
+	RcptTo: string  // SMTP RCPT TO, possibly an alias address.
+	MsgFrom: string  // Address in message From header.
+	Subject: string
+	MessageID: string
+	Size: number
+	Digested: Date  // When the account was sent a digest including this message. Zero if not yet.
+}
+
+// ProtocolSession is an authenticated IMAP or SMTP submission connection for an
+// account. Sessions are recorded so users and admins can see which
+// clients/devices are using an account, and close connections of a lost device.
+export interface ProtocolSession {
+	ID: number
+	Protocol: string  // E.g. "imap", "imaps", "submission", "submissions".
+	LoginAddress: string  // As used during authentication.
+	RemoteIP: string
+	ClientID: string  // Client identification, from IMAP ID command or SMTP EHLO/HELO hostname.
+	Started: Date
+	LastActivity: Date  // Only written to database when session ends, kept in memory while active.
+	Ended: Date  // Zero while active, or if mox stopped while the session was active.
+	Active: boolean  // Set when listing, whether the connection is still active.
+	Closed: boolean  // Whether the session was closed forcibly by user or admin.
+}
+
+// AppPassword is an additional password for an account, for use with IMAP and
+// SMTP submission only, not for logging in to the web interfaces. Each device
+// or email client can get its own app password, which can be removed
+// individually, e.g. when a device is lost. App passwords can only be used with
+// authentication mechanisms that send the password, i.e. IMAP LOGIN and SASL
+// PLAIN/LOGIN, not with SCRAM and CRAM-MD5.
+export interface AppPassword {
+	ID: number
+	Label: string  // Description, e.g. device or email client.
+	Created: Date
+	LastUsed: Date  // Zero if never used.
+	Protocols?: string[] | null  // Protocols the password can be used for, "imap" and/or "smtp". Empty means all.
+	IPNets?: string[] | null  // If non-empty, only allow authentication from these IP networks, in CIDR notation.
+}
+
+// Passkey is a WebAuthn public key credential for logging in to the account and
+// webmail web interfaces without password. An account can have multiple
+// passkeys, e.g. one per device.
+export interface Passkey {
+	ID: number
+	Label: string  // Description, e.g. device.
+	LoginAddress: string  // Address used for sessions after logging in with this passkey.
+	Created: Date
+	LastUsed: Date  // Zero if never used.
+}
+
+// PasskeyCreationOptions are the parameters for registering a passkey with
+// navigator.credentials.create in the browser. Binary values are base64url
+// encoded.
+export interface PasskeyCreationOptions {
+	Challenge: string
+	RPID: string
+	RPName: string
+	UserID: string
+	UserName: string
+	UserDisplayName: string
+	ExcludeCredentialIDs?: string[] | null  // Already registered, to prevent registering an authenticator twice.
+	Algorithms?: number[] | null  // COSE algorithm identifiers.
+	Timeout: number  // In milliseconds.
+}
+
+// PasskeyAttestation is the response from navigator.credentials.create, with
+// base64url encoded values.
+export interface PasskeyAttestation {
+	ClientDataJSON: string
+	AttestationObject: string
+}
+
+// PushSubscription is a Web Push subscription of a browser, for showing
+// notifications about new messages while webmail is not open. Subscriptions are
+// added by webmail on a device, and can be removed through the account settings.
+// Subscriptions are removed automatically when the push service reports they are
+// gone.
+export interface PushSubscription {
+	ID: number
+	Created: Date
+	Endpoint: string  // URL at push service of browser vendor.
+	P256DH: string  // Public key of browser for encrypting messages, base64url-encoded.
+	Auth: string  // Authentication secret for encrypting messages, base64url-encoded.
+	Label: string  // Describes the device, e.g. browser and operating system.
+	LastPush: Date  // Last successful push.
+	LastError: string  // Error of most recent push, empty after successful push.
+}
+
+// SenderListEntry is an entry in the allow/deny sender lists of an account,
+// evaluated during incoming delivery. Entries of the account take precedence
+// over the sender lists of the recipient domain.
+export interface SenderListEntry {
+	ID: number
+	Sender: string  // Email address, or domain as "@domain". In canonical form, see mox.ParseSender.
+	Action: SenderAction
+	Created: Date
+}
+
+// Identity holds settings for sending messages from one of the addresses of the
+// account, used by webmail when composing a message with the address in the From
+// header. Empty fields fall back to the account-wide settings.
+export interface Identity {
+	ID: number
+	Address: string  // Unicode user@domain.
+	FullName: string  // Display name in From header, instead of configured full name.
+	ReplyTo: string  // Address for Reply-To header, optional.
+	Signature: string  // Signature, instead of the signature in the account settings.
+}
+
+// Contact is an entry in the address book of an account. Contacts are managed in
+// the account web interface, synchronized with CardDAV, and used for completing
+// recipient addresses in webmail. Recipients of messages in the Sent mailbox are
+// added automatically, as harvested contacts.
+export interface Contact {
+	ID: number
+	UID: string  // Unique ID, also in the vCard.
+	Href: string  // Name of the vCard resource in the CardDAV address book, e.g. "<uid>.vcf".
+	Created: Date
+	Updated: Date  // Also used for CardDAV ETag.
+	Name: string  // Full name, vCard FN property.
+	Emails?: string[] | null  // Email addresses, normalized.
+	Phones?: string[] | null
+	Organization: string
+	Notes: string
+	Harvested: boolean  // Added automatically as recipient of a sent message. Cleared when the contact is edited.
+}
+
 export type CSRFToken = string
 
 // Localpart is a decoded local part of an email address, before the "@".
@@ -238,11 +454,21 @@ export enum OutgoingEvent {
 	EventUnrecognized = "unrecognized",
 }
 
-export const structTypes: {[typename: string]: boolean} = {"Account":true,"Address":true,"AddressAlias":true,"Alias":true,"AliasAddress":true,"AutomaticJunkFlags":true,"Destination":true,"Domain":true,"ImportProgress":true,"Incoming":true,"IncomingMeta":true,"IncomingWebhook":true,"JunkFilter":true,"NameAddress":true,"Outgoing":true,"OutgoingWebhook":true,"Route":true,"Ruleset":true,"Structure":true,"SubjectPass":true,"Suppression":true}
-export const stringsTypes: {[typename: string]: boolean} = {"CSRFToken":true,"Localpart":true,"OutgoingEvent":true}
+// SenderAction is the action for incoming messages from a sender in the
+// allow/deny sender lists.
+export enum SenderAction {
+	SenderAllow = "allow",  // Skip junk filtering and DNS block list checks. Only for messages with a DMARC pass for the message From address, or SPF pass for the MAIL FROM address.
+	SenderReject = "reject",  // Reject the message.
+	SenderJunk = "junk",  // Deliver to the Junk mailbox, marked as junk.
+}
+
+export const structTypes: {[typename: string]: boolean} = {"Account":true,"Address":true,"AddressAlias":true,"Alias":true,"AliasAddress":true,"AppPassword":true,"AutomaticJunkFlags":true,"ClientInstructions":true,"ClientServer":true,"ClientSettings":true,"Contact":true,"Destination":true,"Domain":true,"Footer":true,"Identity":true,"ImportProgress":true,"Incoming":true,"IncomingMeta":true,"IncomingWebhook":true,"JunkFilter":true,"NameAddress":true,"Outgoing":true,"OutgoingWebhook":true,"Passkey":true,"PasskeyAssertion":true,"PasskeyAttestation":true,"PasskeyCreationOptions":true,"PasskeyRequestOptions":true,"PasswordPolicy":true,"ProtocolSession":true,"PushSubscription":true,"Quarantined":true,"Route":true,"Ruleset":true,"SenderListEntry":true,"Structure":true,"SubjectPass":true,"Suppression":true}
+export const stringsTypes: {[typename: string]: boolean} = {"CSRFToken":true,"Localpart":true,"OutgoingEvent":true,"SenderAction":true}
 export const intsTypes: {[typename: string]: boolean} = {}
 export const types: TypenameMap = {
-	"Account": {"Name":"Account","Docs":"","Fields":[{"Name":"OutgoingWebhook","Docs":"","Typewords":["nullable","OutgoingWebhook"]},{"Name":"IncomingWebhook","Docs":"","Typewords":["nullable","IncomingWebhook"]},{"Name":"FromIDLoginAddresses","Docs":"","Typewords":["[]","string"]},{"Name":"KeepRetiredMessagePeriod","Docs":"","Typewords":["int64"]},{"Name":"KeepRetiredWebhookPeriod","Docs":"","Typewords":["int64"]},{"Name":"Domain","Docs":"","Typewords":["string"]},{"Name":"Description","Docs":"","Typewords":["string"]},{"Name":"FullName","Docs":"","Typewords":["string"]},{"Name":"Destinations","Docs":"","Typewords":["{}","Destination"]},{"Name":"SubjectPass","Docs":"","Typewords":["SubjectPass"]},{"Name":"QuotaMessageSize","Docs":"","Typewords":["int64"]},{"Name":"RejectsMailbox","Docs":"","Typewords":["string"]},{"Name":"KeepRejects","Docs":"","Typewords":["bool"]},{"Name":"AutomaticJunkFlags","Docs":"","Typewords":["AutomaticJunkFlags"]},{"Name":"JunkFilter","Docs":"","Typewords":["nullable","JunkFilter"]},{"Name":"MaxOutgoingMessagesPerDay","Docs":"","Typewords":["int32"]},{"Name":"MaxFirstTimeRecipientsPerDay","Docs":"","Typewords":["int32"]},{"Name":"NoFirstTimeSenderDelay","Docs":"","Typewords":["bool"]},{"Name":"Routes","Docs":"","Typewords":["[]","Route"]},{"Name":"DNSDomain","Docs":"","Typewords":["Domain"]},{"Name":"Aliases","Docs":"","Typewords":["[]","AddressAlias"]}]},
+	"PasskeyRequestOptions": {"Name":"PasskeyRequestOptions","Docs":"","Fields":[{"Name":"Challenge","Docs":"","Typewords":["string"]},{"Name":"RPID","Docs":"","Typewords":["string"]},{"Name":"Timeout","Docs":"","Typewords":["int32"]}]},
+	"PasskeyAssertion": {"Name":"PasskeyAssertion","Docs":"","Fields":[{"Name":"CredentialID","Docs":"","Typewords":["string"]},{"Name":"ClientDataJSON","Docs":"","Typewords":["string"]},{"Name":"AuthenticatorData","Docs":"","Typewords":["string"]},{"Name":"Signature","Docs":"","Typewords":["string"]},{"Name":"UserHandle","Docs":"","Typewords":["string"]}]},
+	"Account": {"Name":"Account","Docs":"","Fields":[{"Name":"OutgoingWebhook","Docs":"","Typewords":["nullable","OutgoingWebhook"]},{"Name":"IncomingWebhook","Docs":"","Typewords":["nullable","IncomingWebhook"]},{"Name":"FromIDLoginAddresses","Docs":"","Typewords":["[]","string"]},{"Name":"KeepRetiredMessagePeriod","Docs":"","Typewords":["int64"]},{"Name":"KeepRetiredWebhookPeriod","Docs":"","Typewords":["int64"]},{"Name":"Domain","Docs":"","Typewords":["string"]},{"Name":"Description","Docs":"","Typewords":["string"]},{"Name":"FullName","Docs":"","Typewords":["string"]},{"Name":"Destinations","Docs":"","Typewords":["{}","Destination"]},{"Name":"SubjectPass","Docs":"","Typewords":["SubjectPass"]},{"Name":"QuotaMessageSize","Docs":"","Typewords":["int64"]},{"Name":"CompressMessages","Docs":"","Typewords":["bool"]},{"Name":"RejectsMailbox","Docs":"","Typewords":["string"]},{"Name":"KeepRejects","Docs":"","Typewords":["bool"]},{"Name":"AutomaticJunkFlags","Docs":"","Typewords":["AutomaticJunkFlags"]},{"Name":"JunkFilter","Docs":"","Typewords":["nullable","JunkFilter"]},{"Name":"MaxOutgoingMessagesPerDay","Docs":"","Typewords":["int32"]},{"Name":"MaxFirstTimeRecipientsPerDay","Docs":"","Typewords":["int32"]},{"Name":"MaxAliases","Docs":"","Typewords":["int32"]},{"Name":"NoFirstTimeSenderDelay","Docs":"","Typewords":["bool"]},{"Name":"RequireTOTP","Docs":"","Typewords":["bool"]},{"Name":"LoginDisabled","Docs":"","Typewords":["string"]},{"Name":"Routes","Docs":"","Typewords":["[]","Route"]},{"Name":"Footer","Docs":"","Typewords":["nullable","Footer"]},{"Name":"PasswordPolicy","Docs":"","Typewords":["nullable","PasswordPolicy"]},{"Name":"DNSDomain","Docs":"","Typewords":["Domain"]},{"Name":"Aliases","Docs":"","Typewords":["[]","AddressAlias"]}]},
 	"OutgoingWebhook": {"Name":"OutgoingWebhook","Docs":"","Fields":[{"Name":"URL","Docs":"","Typewords":["string"]},{"Name":"Authorization","Docs":"","Typewords":["string"]},{"Name":"Events","Docs":"","Typewords":["[]","string"]}]},
 	"IncomingWebhook": {"Name":"IncomingWebhook","Docs":"","Fields":[{"Name":"URL","Docs":"","Typewords":["string"]},{"Name":"Authorization","Docs":"","Typewords":["string"]}]},
 	"Destination": {"Name":"Destination","Docs":"","Fields":[{"Name":"Mailbox","Docs":"","Typewords":["string"]},{"Name":"Rulesets","Docs":"","Typewords":["[]","Ruleset"]},{"Name":"FullName","Docs":"","Typewords":["string"]}]},
@@ -250,25 +476,43 @@ export const types: TypenameMap = {
 	"Domain": {"Name":"Domain","Docs":"","Fields":[{"Name":"ASCII","Docs":"","Typewords":["string"]},{"Name":"Unicode","Docs":"","Typewords":["string"]}]},
 	"SubjectPass": {"Name":"SubjectPass","Docs":"","Fields":[{"Name":"Period","Docs":"","Typewords":["int64"]}]},
 	"AutomaticJunkFlags": {"Name":"AutomaticJunkFlags","Docs":"","Fields":[{"Name":"Enabled","Docs":"","Typewords":["bool"]},{"Name":"JunkMailboxRegexp","Docs":"","Typewords":["string"]},{"Name":"NeutralMailboxRegexp","Docs":"","Typewords":["string"]},{"Name":"NotJunkMailboxRegexp","Docs":"","Typewords":["string"]}]},
-	"JunkFilter": {"Name":"JunkFilter","Docs":"","Fields":[{"Name":"Threshold","Docs":"","Typewords":["float64"]},{"Name":"Onegrams","Docs":"","Typewords":["bool"]},{"Name":"Twograms","Docs":"","Typewords":["bool"]},{"Name":"Threegrams","Docs":"","Typewords":["bool"]},{"Name":"MaxPower","Docs":"","Typewords":["float64"]},{"Name":"TopWords","Docs":"","Typewords":["int32"]},{"Name":"IgnoreWords","Docs":"","Typewords":["float64"]},{"Name":"RareWords","Docs":"","Typewords":["int32"]}]},
+	"JunkFilter": {"Name":"JunkFilter","Docs":"","Fields":[{"Name":"Threshold","Docs":"","Typewords":["float64"]},{"Name":"SharedWeight","Docs":"","Typewords":["float64"]},{"Name":"ContributeShared","Docs":"","Typewords":["bool"]},{"Name":"DelayFlagTraining","Docs":"","Typewords":["bool"]},{"Name":"Onegrams","Docs":"","Typewords":["bool"]},{"Name":"Twograms","Docs":"","Typewords":["bool"]},{"Name":"Threegrams","Docs":"","Typewords":["bool"]},{"Name":"MaxPower","Docs":"","Typewords":["float64"]},{"Name":"TopWords","Docs":"","Typewords":["int32"]},{"Name":"IgnoreWords","Docs":"","Typewords":["float64"]},{"Name":"RareWords","Docs":"","Typewords":["int32"]}]},
 	"Route": {"Name":"Route","Docs":"","Fields":[{"Name":"FromDomain","Docs":"","Typewords":["[]","string"]},{"Name":"ToDomain","Docs":"","Typewords":["[]","string"]},{"Name":"MinimumAttempts","Docs":"","Typewords":["int32"]},{"Name":"Transport","Docs":"","Typewords":["string"]},{"Name":"FromDomainASCII","Docs":"","Typewords":["[]","string"]},{"Name":"ToDomainASCII","Docs":"","Typewords":["[]","string"]}]},
+	"Footer": {"Name":"Footer","Docs":"","Fields":[{"Name":"Text","Docs":"","Typewords":["[]","string"]},{"Name":"HTML","Docs":"","Typewords":["[]","string"]},{"Name":"SkipReplies","Docs":"","Typewords":["bool"]}]},
+	"PasswordPolicy": {"Name":"PasswordPolicy","Docs":"","Fields":[{"Name":"MinLength","Docs":"","Typewords":["int32"]},{"Name":"MinEntropy","Docs":"","Typewords":["int32"]},{"Name":"DenyList","Docs":"","Typewords":["[]","string"]},{"Name":"DenyListFile","Docs":"","Typewords":["string"]},{"Name":"BreachCheck","Docs":"","Typewords":["bool"]},{"Name":"BreachCheckURL","Docs":"","Typewords":["string"]}]},
 	"AddressAlias": {"Name":"AddressAlias","Docs":"","Fields":[{"Name":"SubscriptionAddress","Docs":"","Typewords":["string"]},{"Name":"Alias","Docs":"","Typewords":["Alias"]},{"Name":"MemberAddresses","Docs":"","Typewords":["[]","string"]}]},
-	"Alias": {"Name":"Alias","Docs":"","Fields":[{"Name":"Addresses","Docs":"","Typewords":["[]","string"]},{"Name":"PostPublic","Docs":"","Typewords":["bool"]},{"Name":"ListMembers","Docs":"","Typewords":["bool"]},{"Name":"AllowMsgFrom","Docs":"","Typewords":["bool"]},{"Name":"LocalpartStr","Docs":"","Typewords":["string"]},{"Name":"Domain","Docs":"","Typewords":["Domain"]},{"Name":"ParsedAddresses","Docs":"","Typewords":["[]","AliasAddress"]}]},
+	"Alias": {"Name":"Alias","Docs":"","Fields":[{"Name":"Addresses","Docs":"","Typewords":["[]","string"]},{"Name":"PostPublic","Docs":"","Typewords":["bool"]},{"Name":"ListMembers","Docs":"","Typewords":["bool"]},{"Name":"AllowMsgFrom","Docs":"","Typewords":["bool"]},{"Name":"Owner","Docs":"","Typewords":["string"]},{"Name":"OwnerAddress","Docs":"","Typewords":["string"]},{"Name":"Moderated","Docs":"","Typewords":["bool"]},{"Name":"LocalpartStr","Docs":"","Typewords":["string"]},{"Name":"Domain","Docs":"","Typewords":["Domain"]},{"Name":"ParsedAddresses","Docs":"","Typewords":["[]","AliasAddress"]},{"Name":"ExternalAddresses","Docs":"","Typewords":["[]","Address"]},{"Name":"ParsedOwner","Docs":"","Typewords":["AliasAddress"]}]},
 	"AliasAddress": {"Name":"AliasAddress","Docs":"","Fields":[{"Name":"Address","Docs":"","Typewords":["Address"]},{"Name":"AccountName","Docs":"","Typewords":["string"]},{"Name":"Destination","Docs":"","Typewords":["Destination"]}]},
 	"Address": {"Name":"Address","Docs":"","Fields":[{"Name":"Localpart","Docs":"","Typewords":["Localpart"]},{"Name":"Domain","Docs":"","Typewords":["Domain"]}]},
 	"Suppression": {"Name":"Suppression","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Created","Docs":"","Typewords":["timestamp"]},{"Name":"Account","Docs":"","Typewords":["string"]},{"Name":"BaseAddress","Docs":"","Typewords":["string"]},{"Name":"OriginalAddress","Docs":"","Typewords":["string"]},{"Name":"Manual","Docs":"","Typewords":["bool"]},{"Name":"Reason","Docs":"","Typewords":["string"]}]},
+	"ClientSettings": {"Name":"ClientSettings","Docs":"","Fields":[{"Name":"Address","Docs":"","Typewords":["string"]},{"Name":"IMAP","Docs":"","Typewords":["ClientServer"]},{"Name":"Submission","Docs":"","Typewords":["ClientServer"]},{"Name":"Account","Docs":"","Typewords":["string"]},{"Name":"Webmail","Docs":"","Typewords":["string"]},{"Name":"WebAPI","Docs":"","Typewords":["string"]},{"Name":"DAV","Docs":"","Typewords":["string"]},{"Name":"Autoconfig","Docs":"","Typewords":["string"]},{"Name":"Autodiscover","Docs":"","Typewords":["string"]},{"Name":"MobileConfig","Docs":"","Typewords":["string"]},{"Name":"Clients","Docs":"","Typewords":["[]","ClientInstructions"]}]},
+	"ClientServer": {"Name":"ClientServer","Docs":"","Fields":[{"Name":"Host","Docs":"","Typewords":["string"]},{"Name":"Port","Docs":"","Typewords":["int32"]},{"Name":"Security","Docs":"","Typewords":["string"]}]},
+	"ClientInstructions": {"Name":"ClientInstructions","Docs":"","Fields":[{"Name":"Client","Docs":"","Typewords":["string"]},{"Name":"Steps","Docs":"","Typewords":["[]","string"]}]},
 	"ImportProgress": {"Name":"ImportProgress","Docs":"","Fields":[{"Name":"Token","Docs":"","Typewords":["string"]}]},
 	"Outgoing": {"Name":"Outgoing","Docs":"","Fields":[{"Name":"Version","Docs":"","Typewords":["int32"]},{"Name":"Event","Docs":"","Typewords":["OutgoingEvent"]},{"Name":"DSN","Docs":"","Typewords":["bool"]},{"Name":"Suppressing","Docs":"","Typewords":["bool"]},{"Name":"QueueMsgID","Docs":"","Typewords":["int64"]},{"Name":"FromID","Docs":"","Typewords":["string"]},{"Name":"MessageID","Docs":"","Typewords":["string"]},{"Name":"Subject","Docs":"","Typewords":["string"]},{"Name":"WebhookQueued","Docs":"","Typewords":["timestamp"]},{"Name":"SMTPCode","Docs":"","Typewords":["int32"]},{"Name":"SMTPEnhancedCode","Docs":"","Typewords":["string"]},{"Name":"Error","Docs":"","Typewords":["string"]},{"Name":"Extra","Docs":"","Typewords":["{}","string"]}]},
 	"Incoming": {"Name":"Incoming","Docs":"","Fields":[{"Name":"Version","Docs":"","Typewords":["int32"]},{"Name":"From","Docs":"","Typewords":["[]","NameAddress"]},{"Name":"To","Docs":"","Typewords":["[]","NameAddress"]},{"Name":"CC","Docs":"","Typewords":["[]","NameAddress"]},{"Name":"BCC","Docs":"","Typewords":["[]","NameAddress"]},{"Name":"ReplyTo","Docs":"","Typewords":["[]","NameAddress"]},{"Name":"Subject","Docs":"","Typewords":["string"]},{"Name":"MessageID","Docs":"","Typewords":["string"]},{"Name":"InReplyTo","Docs":"","Typewords":["string"]},{"Name":"References","Docs":"","Typewords":["[]","string"]},{"Name":"Date","Docs":"","Typewords":["nullable","timestamp"]},{"Name":"Text","Docs":"","Typewords":["string"]},{"Name":"HTML","Docs":"","Typewords":["string"]},{"Name":"Structure","Docs":"","Typewords":["Structure"]},{"Name":"Meta","Docs":"","Typewords":["IncomingMeta"]}]},
 	"NameAddress": {"Name":"NameAddress","Docs":"","Fields":[{"Name":"Name","Docs":"","Typewords":["string"]},{"Name":"Address","Docs":"","Typewords":["string"]}]},
 	"Structure": {"Name":"Structure","Docs":"","Fields":[{"Name":"ContentType","Docs":"","Typewords":["string"]},{"Name":"ContentTypeParams","Docs":"","Typewords":["{}","string"]},{"Name":"ContentID","Docs":"","Typewords":["string"]},{"Name":"DecodedSize","Docs":"","Typewords":["int64"]},{"Name":"Parts","Docs":"","Typewords":["[]","Structure"]}]},
 	"IncomingMeta": {"Name":"IncomingMeta","Docs":"","Fields":[{"Name":"MsgID","Docs":"","Typewords":["int64"]},{"Name":"MailFrom","Docs":"","Typewords":["string"]},{"Name":"MailFromValidated","Docs":"","Typewords":["bool"]},{"Name":"MsgFromValidated","Docs":"","Typewords":["bool"]},{"Name":"RcptTo","Docs":"","Typewords":["string"]},{"Name":"DKIMVerifiedDomains","Docs":"","Typewords":["[]","string"]},{"Name":"RemoteIP","Docs":"","Typewords":["string"]},{"Name":"Received","Docs":"","Typewords":["timestamp"]},{"Name":"MailboxName","Docs":"","Typewords":["string"]},{"Name":"Automated","Docs":"","Typewords":["bool"]}]},
+	"Quarantined": {"Name":"Quarantined","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Received","Docs":"","Typewords":["timestamp"]},{"Name":"Expires","Docs":"","Typewords":["timestamp"]},{"Name":"Account","Docs":"","Typewords":["string"]},{"Name":"Mailbox","Docs":"","Typewords":["string"]},{"Name":"Reason","Docs":"","Typewords":["string"]},{"Name":"RemoteIP","Docs":"","Typewords":["string"]},{"Name":"MailFrom","Docs":"","Typewords":["string"]},{"Name":"RcptTo","Docs":"","Typewords":["string"]},{"Name":"MsgFrom","Docs":"","Typewords":["string"]},{"Name":"Subject","Docs":"","Typewords":["string"]},{"Name":"MessageID","Docs":"","Typewords":["string"]},{"Name":"Size","Docs":"","Typewords":["int64"]},{"Name":"Digested","Docs":"","Typewords":["timestamp"]}]},
+	"ProtocolSession": {"Name":"ProtocolSession","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Protocol","Docs":"","Typewords":["string"]},{"Name":"LoginAddress","Docs":"","Typewords":["string"]},{"Name":"RemoteIP","Docs":"","Typewords":["string"]},{"Name":"ClientID","Docs":"","Typewords":["string"]},{"Name":"Started","Docs":"","Typewords":["timestamp"]},{"Name":"LastActivity","Docs":"","Typewords":["timestamp"]},{"Name":"Ended","Docs":"","Typewords":["timestamp"]},{"Name":"Active","Docs":"","Typewords":["bool"]},{"Name":"Closed","Docs":"","Typewords":["bool"]}]},
+	"AppPassword": {"Name":"AppPassword","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Label","Docs":"","Typewords":["string"]},{"Name":"Created","Docs":"","Typewords":["timestamp"]},{"Name":"LastUsed","Docs":"","Typewords":["timestamp"]},{"Name":"Protocols","Docs":"","Typewords":["[]","string"]},{"Name":"IPNets","Docs":"","Typewords":["[]","string"]}]},
+	"Passkey": {"Name":"Passkey","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Label","Docs":"","Typewords":["string"]},{"Name":"LoginAddress","Docs":"","Typewords":["string"]},{"Name":"Created","Docs":"","Typewords":["timestamp"]},{"Name":"LastUsed","Docs":"","Typewords":["timestamp"]}]},
+	"PasskeyCreationOptions": {"Name":"PasskeyCreationOptions","Docs":"","Fields":[{"Name":"Challenge","Docs":"","Typewords":["string"]},{"Name":"RPID","Docs":"","Typewords":["string"]},{"Name":"RPName","Docs":"","Typewords":["string"]},{"Name":"UserID","Docs":"","Typewords":["string"]},{"Name":"UserName","Docs":"","Typewords":["string"]},{"Name":"UserDisplayName","Docs":"","Typewords":["string"]},{"Name":"ExcludeCredentialIDs","Docs":"","Typewords":["[]","string"]},{"Name":"Algorithms","Docs":"","Typewords":["[]","int32"]},{"Name":"Timeout","Docs":"","Typewords":["int32"]}]},
+	"PasskeyAttestation": {"Name":"PasskeyAttestation","Docs":"","Fields":[{"Name":"ClientDataJSON","Docs":"","Typewords":["string"]},{"Name":"AttestationObject","Docs":"","Typewords":["string"]}]},
+	"PushSubscription": {"Name":"PushSubscription","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Created","Docs":"","Typewords":["timestamp"]},{"Name":"Endpoint","Docs":"","Typewords":["string"]},{"Name":"P256DH","Docs":"","Typewords":["string"]},{"Name":"Auth","Docs":"","Typewords":["string"]},{"Name":"Label","Docs":"","Typewords":["string"]},{"Name":"LastPush","Docs":"","Typewords":["timestamp"]},{"Name":"LastError","Docs":"","Typewords":["string"]}]},
+	"SenderListEntry": {"Name":"SenderListEntry","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Sender","Docs":"","Typewords":["string"]},{"Name":"Action","Docs":"","Typewords":["SenderAction"]},{"Name":"Created","Docs":"","Typewords":["timestamp"]}]},
+	"Identity": {"Name":"Identity","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Address","Docs":"","Typewords":["string"]},{"Name":"FullName","Docs":"","Typewords":["string"]},{"Name":"ReplyTo","Docs":"","Typewords":["string"]},{"Name":"Signature","Docs":"","Typewords":["string"]}]},
+	"Contact": {"Name":"Contact","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"UID","Docs":"","Typewords":["string"]},{"Name":"Href","Docs":"","Typewords":["string"]},{"Name":"Created","Docs":"","Typewords":["timestamp"]},{"Name":"Updated","Docs":"","Typewords":["timestamp"]},{"Name":"Name","Docs":"","Typewords":["string"]},{"Name":"Emails","Docs":"","Typewords":["[]","string"]},{"Name":"Phones","Docs":"","Typewords":["[]","string"]},{"Name":"Organization","Docs":"","Typewords":["string"]},{"Name":"Notes","Docs":"","Typewords":["string"]},{"Name":"Harvested","Docs":"","Typewords":["bool"]}]},
 	"CSRFToken": {"Name":"CSRFToken","Docs":"","Values":null},
 	"Localpart": {"Name":"Localpart","Docs":"","Values":null},
 	"OutgoingEvent": {"Name":"OutgoingEvent","Docs":"","Values":[{"Name":"EventDelivered","Value":"delivered","Docs":""},{"Name":"EventSuppressed","Value":"suppressed","Docs":""},{"Name":"EventDelayed","Value":"delayed","Docs":""},{"Name":"EventFailed","Value":"failed","Docs":""},{"Name":"EventRelayed","Value":"relayed","Docs":""},{"Name":"EventExpanded","Value":"expanded","Docs":""},{"Name":"EventCanceled","Value":"canceled","Docs":""},{"Name":"EventUnrecognized","Value":"unrecognized","Docs":""}]},
+	"SenderAction": {"Name":"SenderAction","Docs":"","Values":[{"Name":"SenderAllow","Value":"allow","Docs":""},{"Name":"SenderReject","Value":"reject","Docs":""},{"Name":"SenderJunk","Value":"junk","Docs":""}]},
 }
 
 export const parser = {
+	PasskeyRequestOptions: (v: any) => parse("PasskeyRequestOptions", v) as PasskeyRequestOptions,
+	PasskeyAssertion: (v: any) => parse("PasskeyAssertion", v) as PasskeyAssertion,
 	Account: (v: any) => parse("Account", v) as Account,
 	OutgoingWebhook: (v: any) => parse("OutgoingWebhook", v) as OutgoingWebhook,
 	IncomingWebhook: (v: any) => parse("IncomingWebhook", v) as IncomingWebhook,
@@ -279,20 +523,36 @@ export const parser = {
 	AutomaticJunkFlags: (v: any) => parse("AutomaticJunkFlags", v) as AutomaticJunkFlags,
 	JunkFilter: (v: any) => parse("JunkFilter", v) as JunkFilter,
 	Route: (v: any) => parse("Route", v) as Route,
+	Footer: (v: any) => parse("Footer", v) as Footer,
+	PasswordPolicy: (v: any) => parse("PasswordPolicy", v) as PasswordPolicy,
 	AddressAlias: (v: any) => parse("AddressAlias", v) as AddressAlias,
 	Alias: (v: any) => parse("Alias", v) as Alias,
 	AliasAddress: (v: any) => parse("AliasAddress", v) as AliasAddress,
 	Address: (v: any) => parse("Address", v) as Address,
 	Suppression: (v: any) => parse("Suppression", v) as Suppression,
+	ClientSettings: (v: any) => parse("ClientSettings", v) as ClientSettings,
+	ClientServer: (v: any) => parse("ClientServer", v) as ClientServer,
+	ClientInstructions: (v: any) => parse("ClientInstructions", v) as ClientInstructions,
 	ImportProgress: (v: any) => parse("ImportProgress", v) as ImportProgress,
 	Outgoing: (v: any) => parse("Outgoing", v) as Outgoing,
 	Incoming: (v: any) => parse("Incoming", v) as Incoming,
 	NameAddress: (v: any) => parse("NameAddress", v) as NameAddress,
 	Structure: (v: any) => parse("Structure", v) as Structure,
 	IncomingMeta: (v: any) => parse("IncomingMeta", v) as IncomingMeta,
+	Quarantined: (v: any) => parse("Quarantined", v) as Quarantined,
+	ProtocolSession: (v: any) => parse("ProtocolSession", v) as ProtocolSession,
+	AppPassword: (v: any) => parse("AppPassword", v) as AppPassword,
+	Passkey: (v: any) => parse("Passkey", v) as Passkey,
+	PasskeyCreationOptions: (v: any) => parse("PasskeyCreationOptions", v) as PasskeyCreationOptions,
+	PasskeyAttestation: (v: any) => parse("PasskeyAttestation", v) as PasskeyAttestation,
+	PushSubscription: (v: any) => parse("PushSubscription", v) as PushSubscription,
+	SenderListEntry: (v: any) => parse("SenderListEntry", v) as SenderListEntry,
+	Identity: (v: any) => parse("Identity", v) as Identity,
+	Contact: (v: any) => parse("Contact", v) as Contact,
 	CSRFToken: (v: any) => parse("CSRFToken", v) as CSRFToken,
 	Localpart: (v: any) => parse("Localpart", v) as Localpart,
 	OutgoingEvent: (v: any) => parse("OutgoingEvent", v) as OutgoingEvent,
+	SenderAction: (v: any) => parse("SenderAction", v) as SenderAction,
 }
 
 // Account exports web API functions for the account web interface. All its
@@ -336,12 +596,67 @@ export class Client {
 	}
 
 	// Login returns a session token for the credentials, or fails with error code
-	// "user:badLogin". Call LoginPrep to get a loginToken.
-	async Login(loginToken: string, username: string, password: string): Promise<CSRFToken> {
+	// "user:badLogin". Call LoginPrep to get a loginToken. If two-factor
+	// authentication is enabled and totpCode is empty, the error code is
+	// "user:totpRequired", and the login must be retried with a code.
+	async Login(loginToken: string, username: string, password: string, totpCode: string): Promise<CSRFToken> {
 		const fn: string = "Login"
+		const paramTypes: string[][] = [["string"],["string"],["string"],["string"]]
+		const returnTypes: string[][] = [["CSRFToken"]]
+		const params: any[] = [loginToken, username, password, totpCode]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as CSRFToken
+	}
+
+	// PasskeyLoginPrep returns options for logging in with a passkey with
+	// navigator.credentials.get in the browser.
+	async PasskeyLoginPrep(): Promise<PasskeyRequestOptions> {
+		const fn: string = "PasskeyLoginPrep"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["PasskeyRequestOptions"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as PasskeyRequestOptions
+	}
+
+	// PasskeyLogin returns a session token for a login with a passkey, started with
+	// PasskeyLoginPrep, or fails with error code "user:loginFailed". Call LoginPrep
+	// to get a loginToken after the user selected a passkey.
+	async PasskeyLogin(loginToken: string, response: PasskeyAssertion): Promise<CSRFToken> {
+		const fn: string = "PasskeyLogin"
+		const paramTypes: string[][] = [["string"],["PasskeyAssertion"]]
+		const returnTypes: string[][] = [["CSRFToken"]]
+		const params: any[] = [loginToken, response]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as CSRFToken
+	}
+
+	// OIDCEnabled returns whether single sign-on through an OpenID Connect identity
+	// provider is configured.
+	async OIDCEnabled(): Promise<boolean> {
+		const fn: string = "OIDCEnabled"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["bool"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as boolean
+	}
+
+	// OIDCLoginPrep starts a single sign-on login, returning the URL of the identity
+	// provider to navigate to. The identity provider sends the browser back to this
+	// page with "code" and "state" query string parameters, for OIDCLogin.
+	async OIDCLoginPrep(): Promise<string> {
+		const fn: string = "OIDCLoginPrep"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["string"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as string
+	}
+
+	// OIDCLogin returns a session token for a single sign-on login started with
+	// OIDCLoginPrep, or fails with error code "user:loginFailed". Call LoginPrep to
+	// get a loginToken.
+	async OIDCLogin(loginToken: string, code: string, state: string): Promise<CSRFToken> {
+		const fn: string = "OIDCLogin"
 		const paramTypes: string[][] = [["string"],["string"],["string"]]
 		const returnTypes: string[][] = [["CSRFToken"]]
-		const params: any[] = [loginToken, username, password]
+		const params: any[] = [loginToken, code, state]
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as CSRFToken
 	}
 
@@ -356,7 +671,7 @@ export class Client {
 
 	// SetPassword saves a new password for the account, invalidating the previous password.
 	// Sessions are not interrupted, and will keep working. New login attempts must use the new password.
-	// Password must be at least 8 characters.
+	// Password must be at least 8 characters, and meet the password policy.
 	async SetPassword(password: string): Promise<void> {
 		const fn: string = "SetPassword"
 		const paramTypes: string[][] = [["string"]]
@@ -365,6 +680,27 @@ export class Client {
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
 	}
 
+	// PasswordStatus returns whether an admin requires the password to be changed,
+	// and the requirements for new passwords from the password policy.
+	async PasswordStatus(): Promise<[boolean, number, number, boolean]> {
+		const fn: string = "PasswordStatus"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["bool"],["int32"],["int32"],["bool"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as [boolean, number, number, boolean]
+	}
+
+	// AccountDelete requests deletion of the account, after verifying the password.
+	// Logins are disabled immediately, and the session is ended. The account and all
+	// its data are removed at purgeAfter, unless an admin cancels the deletion before.
+	async AccountDelete(password: string): Promise<Date> {
+		const fn: string = "AccountDelete"
+		const paramTypes: string[][] = [["string"]]
+		const returnTypes: string[][] = [["timestamp"]]
+		const params: any[] = [password]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as Date
+	}
+
 	// Account returns information about the account.
 	// StorageUsed is the sum of the sizes of all messages, in bytes.
 	// StorageLimit is the maximum storage that can be used, or 0 if there is no limit.
@@ -376,6 +712,17 @@ export class Client {
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as [Account, number, number, Suppression[] | null]
 	}
 
+	// ClientSettings returns the settings for configuring email, calendar and
+	// contacts applications for an address of the account, with setup instructions
+	// for common applications.
+	async ClientSettings(address: string): Promise<ClientSettings> {
+		const fn: string = "ClientSettings"
+		const paramTypes: string[][] = [["string"]]
+		const returnTypes: string[][] = [["ClientSettings"]]
+		const params: any[] = [address]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as ClientSettings
+	}
+
 	// AccountSaveFullName saves the full name (used as display name in email messages)
 	// for the account.
 	async AccountSaveFullName(fullName: string): Promise<void> {
@@ -386,6 +733,25 @@ export class Client {
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
 	}
 
+	// Language returns the language configured for the account, used for the web
+	// interfaces and messages generated by mox. Empty means the browser default.
+	async Language(): Promise<string> {
+		const fn: string = "Language"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["string"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as string
+	}
+
+	// LanguageSave saves the language for the account, empty for the browser default.
+	async LanguageSave(language: string): Promise<void> {
+		const fn: string = "LanguageSave"
+		const paramTypes: string[][] = [["string"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [language]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
 	// DestinationSave updates a destination.
 	// OldDest is compared against the current destination. If it does not match, an
 	// error is returned. Otherwise newDest is saved and the configuration reloaded.
@@ -407,6 +773,20 @@ export class Client {
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
 	}
 
+	// ImportIMAP starts a migration of all mailboxes and messages from an account
+	// at a remote IMAP server, with tlsMode "tls" or "starttls". Progress can be
+	// tracked and the migration aborted like an import. Messages added before an
+	// abort are kept. Starting a migration again continues where an earlier one
+	// stopped, adds new messages and updates flags of migrated messages. Hosts with
+	// internal IPs are refused.
+	async ImportIMAP(host: string, tlsMode: string, username: string, password: string, prefix: string): Promise<ImportProgress> {
+		const fn: string = "ImportIMAP"
+		const paramTypes: string[][] = [["string"],["string"],["string"],["string"],["string"]]
+		const returnTypes: string[][] = [["ImportProgress"]]
+		const params: any[] = [host, tlsMode, username, password, prefix]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as ImportProgress
+	}
+
 	// Types exposes types not used in API method signatures, such as the import form upload.
 	async Types(): Promise<ImportProgress> {
 		const fn: string = "Types"
@@ -517,6 +897,66 @@ export class Client {
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
 	}
 
+	// JunkFilterTrainingSave saves whether retraining the junk filter after changes
+	// to junk/nonjunk flags of messages is delayed until the daily retraining of the
+	// backlog.
+	async JunkFilterTrainingSave(delay: boolean): Promise<void> {
+		const fn: string = "JunkFilterTrainingSave"
+		const paramTypes: string[][] = [["bool"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [delay]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// JunkFilterRetrainBacklog retrains the junk filter with messages whose
+	// junk/nonjunk flags changed since they were last trained. Returns the number of
+	// retrained messages.
+	async JunkFilterRetrainBacklog(): Promise<number> {
+		const fn: string = "JunkFilterRetrainBacklog"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["int32"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as number
+	}
+
+	// Quarantined returns the messages for the account held in quarantine, most
+	// recent first.
+	async Quarantined(): Promise<Quarantined[] | null> {
+		const fn: string = "Quarantined"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["[]","Quarantined"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as Quarantined[] | null
+	}
+
+	// QuarantineHeaders returns the header section of a quarantined message.
+	async QuarantineHeaders(id: number): Promise<string> {
+		const fn: string = "QuarantineHeaders"
+		const paramTypes: string[][] = [["int64"]]
+		const returnTypes: string[][] = [["string"]]
+		const params: any[] = [id]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as string
+	}
+
+	// QuarantineRelease delivers a quarantined message to the mailbox it was destined
+	// for.
+	async QuarantineRelease(id: number): Promise<void> {
+		const fn: string = "QuarantineRelease"
+		const paramTypes: string[][] = [["int64"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [id]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// QuarantineRemove removes a quarantined message without delivering it.
+	async QuarantineRemove(id: number): Promise<void> {
+		const fn: string = "QuarantineRemove"
+		const paramTypes: string[][] = [["int64"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [id]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
 	// RejectsSave saves the RejectsMailbox and KeepRejects settings.
 	async RejectsSave(mailbox: string, keep: boolean): Promise<void> {
 		const fn: string = "RejectsSave"
@@ -525,6 +965,357 @@ export class Client {
 		const params: any[] = [mailbox, keep]
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
 	}
+
+	// ProtocolSessions returns the active and recent IMAP and SMTP submission
+	// sessions of the account, e.g. to see which devices are using the account.
+	async ProtocolSessions(): Promise<ProtocolSession[] | null> {
+		const fn: string = "ProtocolSessions"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["[]","ProtocolSession"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as ProtocolSession[] | null
+	}
+
+	// ProtocolSessionClose closes an active IMAP or SMTP submission session, e.g.
+	// of a lost device. If id is 0, all active sessions are closed. The device can
+	// log in again unless the password is changed.
+	async ProtocolSessionClose(id: number): Promise<number> {
+		const fn: string = "ProtocolSessionClose"
+		const paramTypes: string[][] = [["int64"]]
+		const returnTypes: string[][] = [["int32"]]
+		const params: any[] = [id]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as number
+	}
+
+	// AppPasswords returns the app passwords of the account. App passwords can be
+	// used for IMAP and SMTP submission, not for the web interfaces.
+	async AppPasswords(): Promise<AppPassword[] | null> {
+		const fn: string = "AppPasswords"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["[]","AppPassword"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as AppPassword[] | null
+	}
+
+	// AppPasswordAdd adds a new app password with a random password. The password is
+	// only returned now, it cannot be retrieved later. Protocols can be "imap" and/or
+	// "smtp", empty means all. If ipNets is non-empty, authentication is only allowed
+	// from those IPs/networks.
+	async AppPasswordAdd(label: string, protocols: string[] | null, ipNets: string[] | null): Promise<[AppPassword, string]> {
+		const fn: string = "AppPasswordAdd"
+		const paramTypes: string[][] = [["string"],["[]","string"],["[]","string"]]
+		const returnTypes: string[][] = [["AppPassword"],["string"]]
+		const params: any[] = [label, protocols, ipNets]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as [AppPassword, string]
+	}
+
+	// AppPasswordRemove removes an app password. Sessions that authenticated with the
+	// app password are not closed, see ProtocolSessionClose.
+	async AppPasswordRemove(id: number): Promise<void> {
+		const fn: string = "AppPasswordRemove"
+		const paramTypes: string[][] = [["int64"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [id]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// TOTPStatus returns whether two-factor authentication with time-based one-time
+	// passwords is enabled for logging in to the web interfaces, whether it is
+	// required by the account or domain configuration, and the number of unused
+	// recovery codes.
+	async TOTPStatus(): Promise<[boolean, boolean, number]> {
+		const fn: string = "TOTPStatus"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["bool"],["bool"],["int32"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as [boolean, boolean, number]
+	}
+
+	// TOTPSetup starts setting up two-factor authentication. The returned secret (in
+	// base32), otpauth URI and QR code (PNG as data URL, of the URI) can be added to
+	// an authenticator app. Setup must be finished with TOTPEnable.
+	async TOTPSetup(): Promise<[string, string, string]> {
+		const fn: string = "TOTPSetup"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["string"],["string"],["string"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as [string, string, string]
+	}
+
+	// TOTPEnable finishes setup of two-factor authentication by verifying a code from
+	// the authenticator app. Recovery codes are returned, each can be used once
+	// instead of a code from the authenticator app, e.g. when the device is lost.
+	// Once enabled, the account password can no longer be used for IMAP and SMTP
+	// submission, app passwords must be used instead.
+	async TOTPEnable(code: string): Promise<string[] | null> {
+		const fn: string = "TOTPEnable"
+		const paramTypes: string[][] = [["string"]]
+		const returnTypes: string[][] = [["[]","string"]]
+		const params: any[] = [code]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as string[] | null
+	}
+
+	// TOTPRecoveryCodesReset generates new recovery codes, invalidating all previous
+	// recovery codes.
+	async TOTPRecoveryCodesReset(): Promise<string[] | null> {
+		const fn: string = "TOTPRecoveryCodesReset"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["[]","string"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as string[] | null
+	}
+
+	// TOTPDisable disables two-factor authentication, after verifying a code from the
+	// authenticator app or a recovery code. Not allowed if two-factor authentication
+	// is required by the configuration.
+	async TOTPDisable(code: string): Promise<void> {
+		const fn: string = "TOTPDisable"
+		const paramTypes: string[][] = [["string"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [code]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// Passkeys returns the passkeys of the account, for logging in to the account and
+	// webmail web interfaces without password.
+	async Passkeys(): Promise<Passkey[] | null> {
+		const fn: string = "Passkeys"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["[]","Passkey"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as Passkey[] | null
+	}
+
+	// PasskeyRegisterPrep returns options for registering a new passkey with
+	// navigator.credentials.create in the browser. Finish registration with
+	// PasskeyRegister.
+	async PasskeyRegisterPrep(): Promise<PasskeyCreationOptions> {
+		const fn: string = "PasskeyRegisterPrep"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["PasskeyCreationOptions"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as PasskeyCreationOptions
+	}
+
+	// PasskeyRegister finishes registration of a passkey started with
+	// PasskeyRegisterPrep, with the response of navigator.credentials.create. Logins
+	// with the passkey get the login address of the current session.
+	async PasskeyRegister(label: string, response: PasskeyAttestation): Promise<Passkey> {
+		const fn: string = "PasskeyRegister"
+		const paramTypes: string[][] = [["string"],["PasskeyAttestation"]]
+		const returnTypes: string[][] = [["Passkey"]]
+		const params: any[] = [label, response]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as Passkey
+	}
+
+	// PasskeyRemove removes a passkey. Existing sessions are not affected.
+	async PasskeyRemove(id: number): Promise<void> {
+		const fn: string = "PasskeyRemove"
+		const paramTypes: string[][] = [["int64"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [id]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// PushSubscriptions returns the Web Push subscriptions of webmail on devices, for
+	// notifications about new messages.
+	async PushSubscriptions(): Promise<PushSubscription[] | null> {
+		const fn: string = "PushSubscriptions"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["[]","PushSubscription"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as PushSubscription[] | null
+	}
+
+	// PushSubscriptionRemove removes a Web Push subscription. The device no longer
+	// gets notifications.
+	async PushSubscriptionRemove(id: number): Promise<void> {
+		const fn: string = "PushSubscriptionRemove"
+		const paramTypes: string[][] = [["int64"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [id]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// PushSubscriptionTest sends a test notification to a Web Push subscription.
+	async PushSubscriptionTest(id: number): Promise<void> {
+		const fn: string = "PushSubscriptionTest"
+		const paramTypes: string[][] = [["int64"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [id]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// RemoteContentSenders returns the senders for which external resources in HTML
+	// messages are loaded by default in webmail. Senders are email addresses, or
+	// domains as "@domain".
+	async RemoteContentSenders(): Promise<string[] | null> {
+		const fn: string = "RemoteContentSenders"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["[]","string"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as string[] | null
+	}
+
+	// RemoteContentSenderAdd adds a sender, an email address or "@domain", for which
+	// external resources in HTML messages are loaded by default in webmail.
+	async RemoteContentSenderAdd(sender: string): Promise<void> {
+		const fn: string = "RemoteContentSenderAdd"
+		const paramTypes: string[][] = [["string"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [sender]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// RemoteContentSenderRemove removes a sender from the senders for which
+	// external resources are loaded by default.
+	async RemoteContentSenderRemove(sender: string): Promise<void> {
+		const fn: string = "RemoteContentSenderRemove"
+		const paramTypes: string[][] = [["string"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [sender]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// SenderList returns the allow/deny sender lists of the account, evaluated
+	// during incoming delivery.
+	async SenderList(): Promise<SenderListEntry[] | null> {
+		const fn: string = "SenderList"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["[]","SenderListEntry"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as SenderListEntry[] | null
+	}
+
+	// SenderListSet adds a sender, an email address or "@domain", to the sender
+	// lists with action "allow", "reject" or "junk", replacing the action for a
+	// sender already present.
+	async SenderListSet(sender: string, action: SenderAction): Promise<SenderListEntry> {
+		const fn: string = "SenderListSet"
+		const paramTypes: string[][] = [["string"],["SenderAction"]]
+		const returnTypes: string[][] = [["SenderListEntry"]]
+		const params: any[] = [sender, action]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as SenderListEntry
+	}
+
+	// SenderListRemove removes an entry from the sender lists.
+	async SenderListRemove(id: number): Promise<void> {
+		const fn: string = "SenderListRemove"
+		const paramTypes: string[][] = [["int64"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [id]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// AliasesOwned returns the addresses of aliases created by the account itself,
+	// and the maximum number of aliases the account can create.
+	async AliasesOwned(): Promise<[string[] | null, number]> {
+		const fn: string = "AliasesOwned"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["[]","string"],["int32"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as [string[] | null, number]
+	}
+
+	// AliasCreate creates a new alias in a domain of one of the addresses of the
+	// account, with member as only member. Member must be an address of the account.
+	// The number of aliases an account can create is limited by MaxAliases in its
+	// configuration.
+	async AliasCreate(localpart: string, domain: string, member: string, allowMsgFrom: boolean): Promise<void> {
+		const fn: string = "AliasCreate"
+		const paramTypes: string[][] = [["string"],["string"],["string"],["bool"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [localpart, domain, member, allowMsgFrom]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// AliasDelete removes an alias created by the account.
+	async AliasDelete(address: string): Promise<void> {
+		const fn: string = "AliasDelete"
+		const paramTypes: string[][] = [["string"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [address]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// Identities returns the sending identities of the account, with settings per
+	// address used by webmail when composing messages.
+	async Identities(): Promise<Identity[] | null> {
+		const fn: string = "Identities"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["[]","Identity"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as Identity[] | null
+	}
+
+	// IdentitySave stores the settings for sending from an address, replacing any
+	// existing identity for the address. The address must be an address of the
+	// account, or an alias that allows sending with its address.
+	async IdentitySave(identity: Identity): Promise<Identity> {
+		const fn: string = "IdentitySave"
+		const paramTypes: string[][] = [["Identity"]]
+		const returnTypes: string[][] = [["Identity"]]
+		const params: any[] = [identity]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as Identity
+	}
+
+	// IdentityRemove removes an identity.
+	async IdentityRemove(id: number): Promise<void> {
+		const fn: string = "IdentityRemove"
+		const paramTypes: string[][] = [["int64"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [id]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// Contacts returns the contacts in the address book of the account, sorted by
+	// name. Includes contacts harvested from recipients of sent messages.
+	async Contacts(): Promise<Contact[] | null> {
+		const fn: string = "Contacts"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["[]","Contact"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as Contact[] | null
+	}
+
+	// ContactSave adds a new contact if ID is zero, or updates an existing contact.
+	// The saved contact is returned.
+	async ContactSave(contact: Contact): Promise<Contact> {
+		const fn: string = "ContactSave"
+		const paramTypes: string[][] = [["Contact"]]
+		const returnTypes: string[][] = [["Contact"]]
+		const params: any[] = [contact]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as Contact
+	}
+
+	// ContactRemove removes a contact.
+	async ContactRemove(id: number): Promise<void> {
+		const fn: string = "ContactRemove"
+		const paramTypes: string[][] = [["int64"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [id]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// ContactsImport adds contacts from one or more vCards, e.g. exported from another
+	// address book. Existing contacts with the same UID are updated. The number of
+	// added/updated contacts is returned.
+	async ContactsImport(vcards: string): Promise<number> {
+		const fn: string = "ContactsImport"
+		const paramTypes: string[][] = [["string"]]
+		const returnTypes: string[][] = [["int32"]]
+		const params: any[] = [vcards]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as number
+	}
+
+	// ContactsExport returns all contacts as vCards.
+	async ContactsExport(): Promise<string> {
+		const fn: string = "ContactsExport"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["string"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as string
+	}
 }
 
 export const defaultBaseURL = (function() {
@@ -27,10 +27,12 @@ import (
 
 	"github.com/mjl-/bstore"
 
+	"github.com/mjl-/mox/imapmigrate"
 	"github.com/mjl-/mox/message"
 	"github.com/mjl-/mox/metrics"
 	"github.com/mjl-/mox/mlog"
 	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/pst"
 	"github.com/mjl-/mox/store"
 )
 
@@ -219,22 +221,32 @@ func importStart(log mlog.Log, accName string, f *os.File, skipMailboxPrefix str
 	}
 
 	// Recognize file format.
-	var iszip bool
+	var iszip, ispst bool
 	magicZip := []byte{0x50, 0x4b, 0x03, 0x04}
 	magicGzip := []byte{0x1f, 0x8b}
+	magicPST := []byte("!BDN")
 	magic := make([]byte, 4)
 	if _, err := f.ReadAt(magic, 0); err != nil {
 		return "", true, fmt.Errorf("detecting file format: %v", err)
 	}
 	if bytes.Equal(magic, magicZip) {
 		iszip = true
+	} else if bytes.Equal(magic, magicPST) {
+		ispst = true
 	} else if !bytes.Equal(magic[:2], magicGzip) {
-		return "", true, fmt.Errorf("file is not a zip or gzip file")
+		return "", true, fmt.Errorf("file is not a zip, gzip or pst file")
 	}
 
 	var zr *zip.Reader
 	var tr *tar.Reader
-	if iszip {
+	var pf *pst.File
+	if ispst {
+		var err error
+		pf, err = pst.Open(f)
+		if err != nil {
+			return "", true, fmt.Errorf("opening pst file: %v", err)
+		}
+	} else if iszip {
 		fi, err := f.Stat()
 		if err != nil {
 			return "", false, fmt.Errorf("stat temporary import zip file: %v", err)
@@ -270,15 +282,15 @@ func importStart(log mlog.Log, accName string, f *os.File, skipMailboxPrefix str
 	importers.Events <- importEvent{token, []byte(": keepalive\n\n"), nil, cancel}
 
 	log.Info("starting import")
-	go importMessages(ctx, log.WithCid(mox.Cid()), token, acc, tx, zr, tr, f, skipMailboxPrefix)
+	go importMessages(ctx, log.WithCid(mox.Cid()), token, acc, tx, zr, tr, pf, f, skipMailboxPrefix)
 	f = nil // importMessages is now responsible for closing and removing.
 
 	return token, false, nil
 }
 
-// importMessages imports the messages from zip/tgz file f.
+// importMessages imports the messages from zip/tgz/pst file f.
 // importMessages is responsible for unlocking and closing acc, and closing tx and f.
-func importMessages(ctx context.Context, log mlog.Log, token string, acc *store.Account, tx *bstore.Tx, zr *zip.Reader, tr *tar.Reader, f *os.File, skipMailboxPrefix string) {
+func importMessages(ctx context.Context, log mlog.Log, token string, acc *store.Account, tx *bstore.Tx, zr *zip.Reader, tr *tar.Reader, pf *pst.File, f *os.File, skipMailboxPrefix string) {
 	// If a fatal processing error occurs, we panic with this type.
 	type importError struct{ Err error }
 
@@ -385,6 +397,10 @@ func importMessages(ctx context.Context, log mlog.Log, token string, acc *store.
 	mailboxKeywords := map[string]map[rune]string{}                // Mailbox to 'a'-'z' to flag name.
 	mailboxMissingKeywordMessages := map[string]map[int64]string{} // Mailbox to message id to string consisting of the unrecognized flags.
 
+	// For .eml files, metadata with flags and received time comes before the
+	// messages, as written by an eml export.
+	emlMetadata := map[string]map[string]store.EMLMessage{} // Mailbox to file name to metadata.
+
 	// We keep the mailboxes we deliver to up to date with count and keywords (non-system flags).
 	destMailboxCounts := map[int64]store.MailboxCounts{}
 	destMailboxKeywords := map[int64]map[string]bool{}
@@ -411,17 +427,12 @@ func importMessages(ctx context.Context, log mlog.Log, token string, acc *store.
 	}
 
 	openTrainMessage := func(m *store.Message) {
-		path := acc.MessagePath(m.ID)
-		f, err := os.Open(path)
-		if err != nil {
-			problemf("opening message again for training junk filter: %v (continuing)", err)
-			return
-		}
+		msgr := acc.MessageReader(*m)
 		defer func() {
-			err := f.Close()
-			log.Check(err, "closing file after training junkfilter")
+			err := msgr.Close()
+			log.Check(err, "closing message reader after training junkfilter")
 		}()
-		p, err := m.LoadPart(f)
+		p, err := m.LoadPart(msgr)
 		if err != nil {
 			problemf("loading parsed message again for training junk filter: %v (continuing)", err)
 			return
@@ -528,13 +539,13 @@ func importMessages(ctx context.Context, log mlog.Log, token string, acc *store.
 		if err != nil {
 			problemf("parsing message %s: %s (continuing)", pos, err)
 		}
-		m.ParsedBuf, err = json.Marshal(p)
+		m.Sealed.ParsedBuf, err = json.Marshal(p)
 		ximportcheckf(err, "marshal parsed message structure")
 
 		// Set fields needed for future threading. By doing it now, DeliverMessage won't
 		// have to parse the Part again.
-		p.SetReaderAt(store.FileMsgReader(m.MsgPrefix, f))
-		m.PrepareThreading(log, &p)
+		p.SetReaderAt(store.FileMsgReader(m.Sealed.MsgPrefix, f))
+		m.PrepareThreading(log, acc.Name, &p)
 
 		if m.Received.IsZero() {
 			if p.Envelope != nil && !p.Envelope.Date.IsZero() {
@@ -589,22 +600,8 @@ func importMessages(ctx context.Context, log mlog.Log, token string, acc *store.
 		}
 	}
 
-	ximportMaildir := func(mailbox, filename string, r io.Reader) {
-		if mailbox == "" {
-			problemf("empty mailbox name for maildir file %s (skipping)", filename)
-			return
-		}
-		mb := xensureMailbox(mailbox)
-
-		f, err := store.CreateMessageTemp(log, "import")
-		ximportcheckf(err, "creating temp message")
-		defer func() {
-			if f != nil {
-				store.CloseRemoveTempFile(log, f, "message to import")
-			}
-		}()
-
-		// Copy data, changing bare \n into \r\n.
+	// Copy message from r to f, changing bare \n into \r\n.
+	xcopyMessage := func(f *os.File, r io.Reader) int64 {
 		br := bufio.NewReader(r)
 		w := bufio.NewWriter(f)
 		var size int64
@@ -626,8 +623,91 @@ func importMessages(ctx context.Context, log mlog.Log, token string, acc *store.
 				break
 			}
 		}
-		err = w.Flush()
+		err := w.Flush()
 		ximportcheckf(err, "writing message")
+		return size
+	}
+
+	ximportEML := func(mailbox, filename string, r io.Reader) {
+		if mailbox == "." {
+			problemf("no mailbox for eml file %s, must be in a directory (skipping)", filename)
+			return
+		}
+		mb := xensureMailbox(mailbox)
+
+		f, err := store.CreateMessageTemp(log, "import")
+		ximportcheckf(err, "creating temp message")
+		defer func() {
+			if f != nil {
+				store.CloseRemoveTempFile(log, f, "message to import")
+			}
+		}()
+
+		m := store.Message{Size: xcopyMessage(f, r)}
+		if md, ok := emlMetadata[mailbox][path.Base(filename)]; ok {
+			m.Received = md.Received
+			flags, keywords, err := store.ParseFlagsKeywords(md.Flags)
+			if err != nil {
+				problemf("parsing flags for eml file %s: %v (continuing without flags)", filename, err)
+			} else {
+				m.Flags = flags
+				m.Keywords = keywords
+			}
+		}
+		xdeliver(mb, &m, f, filename)
+		f = nil
+	}
+
+	// ximportPST returns false if the import was canceled.
+	ximportPST := func() bool {
+		folders, err := pf.Folders()
+		ximportcheckf(err, "reading folders from pst file")
+		for _, folder := range folders {
+			if canceled() {
+				return false
+			}
+			elems := make([]string, len(folder.Path))
+			for i, e := range folder.Path {
+				elems[i] = strings.ReplaceAll(e, "/", "-")
+			}
+			mailbox := strings.Join(elems, "/")
+			mb := xensureMailbox(mailbox)
+
+			mr, err := store.NewPSTReader(log, store.CreateMessageTemp, pf, folder)
+			if err != nil {
+				problemf("reading messages of pst folder %s: %v (skipping)", mailbox, err)
+				continue
+			}
+			for {
+				m, mf, pos, err := mr.Next()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					problemf("%s: %v (skipping)", pos, err)
+					continue
+				}
+				xdeliver(mb, m, mf, pos)
+			}
+		}
+		return true
+	}
+
+	ximportMaildir := func(mailbox, filename string, r io.Reader) {
+		if mailbox == "" {
+			problemf("empty mailbox name for maildir file %s (skipping)", filename)
+			return
+		}
+		mb := xensureMailbox(mailbox)
+
+		f, err := store.CreateMessageTemp(log, "import")
+		ximportcheckf(err, "creating temp message")
+		defer func() {
+			if f != nil {
+				store.CloseRemoveTempFile(log, f, "message to import")
+			}
+		}()
+
+		size := xcopyMessage(f, r)
 
 		var received time.Time
 		t := strings.SplitN(path.Base(filename), ".", 2)
@@ -710,6 +790,10 @@ func importMessages(ctx context.Context, log mlog.Log, token string, acc *store.
 			ximportMbox(mailbox, origName, r)
 			return
 		}
+		if strings.HasSuffix(strings.ToLower(path.Base(name)), ".eml") {
+			ximportEML(path.Dir(name), origName, r)
+			return
+		}
 		dir := path.Dir(name)
 		dirbase := path.Base(dir)
 		switch dirbase {
@@ -775,13 +859,26 @@ func importMessages(ctx context.Context, log mlog.Log, token string, acc *store.
 					changes = append(changes, m.ChangeFlags(oflags))
 				}
 				delete(mailboxMissingKeywordMessages, mailbox)
+			} else if path.Base(name) == store.EMLMetadataFile && path.Dir(name) != "." {
+				mailbox := path.Dir(name)
+				xensureMailbox(mailbox)
+				md, err := store.ParseEMLMetadata(r)
+				if err != nil {
+					problemf("parsing eml metadata file %s: %v (continuing without flags)", origName, err)
+				} else {
+					emlMetadata[mailbox] = md
+				}
 			} else {
 				problemf("unrecognized file %s (skipping)", origName)
 			}
 		}
 	}
 
-	if zr != nil {
+	if pf != nil {
+		if !ximportPST() {
+			return
+		}
+	} else if zr != nil {
 		for _, f := range zr.File {
 			if canceled() {
 				return
@@ -894,3 +991,77 @@ func flagSet(flags *store.Flags, keywords map[string]bool, word string) {
 		}
 	}
 }
+
+// importIMAPStart starts a migration from a remote IMAP server in a goroutine,
+// with progress reported like for imports of uploaded files.
+func importIMAPStart(log mlog.Log, accName string, remote imapmigrate.Remote, prefix string) (string, error) {
+	buf := make([]byte, 16)
+	if _, err := cryptrand.Read(buf); err != nil {
+		return "", err
+	}
+	token := fmt.Sprintf("%x", buf)
+
+	acc, err := store.OpenAccount(log, accName)
+	if err != nil {
+		return "", fmt.Errorf("open acount: %v", err)
+	}
+
+	// Ensure token is registered before returning, with context that can be canceled.
+	ctx, cancel := context.WithCancel(mox.Shutdown)
+	importers.Events <- importEvent{token, []byte(": keepalive\n\n"), nil, cancel}
+
+	log.Info("starting migration from remote imap server", slog.String("host", remote.Host), slog.String("username", remote.Username))
+	go importIMAP(ctx, log.WithCid(mox.Cid()), token, acc, remote, prefix)
+	return token, nil
+}
+
+// importIMAP migrates mailboxes and messages from a remote IMAP server.
+// importIMAP is responsible for closing acc.
+func importIMAP(ctx context.Context, log mlog.Log, token string, acc *store.Account, remote imapmigrate.Remote, prefix string) {
+	sendEvent := func(kind string, v any) {
+		buf, err := json.Marshal(v)
+		if err != nil {
+			log.Errorx("marshal event", err, slog.String("kind", kind), slog.Any("event", v))
+			return
+		}
+		ssemsg := fmt.Sprintf("event: %s\ndata: %s\n\n", kind, buf)
+		importers.Events <- importEvent{token, []byte(ssemsg), v, nil}
+	}
+
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account after migration")
+
+		x := recover()
+		if x == nil {
+			return
+		}
+		log.Error("migration panic", slog.Any("err", x))
+		debug.PrintStack()
+		metrics.PanicInc(metrics.Importmessages)
+		sendEvent("problem", importProblem{"internal error during migration"})
+		sendEvent("aborted", importAborted{})
+	}()
+
+	opts := imapmigrate.Options{
+		Prefix: prefix,
+		Progress: func(mailbox string, count int) {
+			sendEvent("count", importCount{mailbox, count})
+		},
+		Problem: func(msg string) {
+			sendEvent("problem", importProblem{msg})
+		},
+	}
+	result, err := imapmigrate.Migrate(ctx, log, acc, remote, opts)
+	if err != nil {
+		log.Errorx("migration from remote imap server", err)
+		if ctx.Err() == nil {
+			sendEvent("problem", importProblem{fmt.Sprintf("%s (aborting)", err)})
+		}
+		// Unlike imports of files, messages added so far are kept.
+		sendEvent("aborted", importAborted{})
+		return
+	}
+	sendEvent("step", importStep{fmt.Sprintf("%d mailboxes migrated, %d messages added, %d already present, %d with updated flags.", result.Mailboxes, result.Messages, result.Skipped, result.Flags)})
+	sendEvent("done", importDone{})
+}
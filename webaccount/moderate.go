@@ -0,0 +1,105 @@
+package webaccount
+
+import (
+	"context"
+	"errors"
+	htmltemplate "html/template"
+	"log/slog"
+	"net/http"
+
+	"github.com/mjl-/mox/admindb"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/moderation"
+)
+
+var moderateTemplate = htmltemplate.Must(htmltemplate.New("moderate").Parse(`<!doctype html>
+<html>
+	<head>
+		<meta charset="utf-8" />
+		<meta name="robots" content="noindex,nofollow" />
+		<title>Held message - Mox</title>
+		<style>
+body, html { padding: 1em; font-size: 16px; }
+* { font-size: inherit; font-family: ubuntu, lato, sans-serif; margin: 0; padding: 0; box-sizing: border-box; }
+h1 { font-size: 1.2rem; margin-bottom: 1ex; }
+p, table { margin-bottom: 1em; max-width: 50em; }
+td, th { padding: .25em .5em; text-align: left; vertical-align: top; }
+button { padding: .25em .5em; margin-right: .5em; }
+		</style>
+	</head>
+	<body>
+		<h1>Held message for {{ .Held.Alias }}</h1>
+{{ if .Result }}
+		<p>{{ .Result }}</p>
+{{ else }}
+		<p>This message was sent to a moderated alias by a sender that is not a member. Approve the message to deliver it to the members, or reject it to remove it.</p>
+		<table>
+			<tr><th>From</th><td>{{ .Held.MsgFrom }}</td></tr>
+			<tr><th>SMTP MAIL FROM</th><td>{{ .Held.MailFrom }}</td></tr>
+			<tr><th>Subject</th><td>{{ .Held.Subject }}</td></tr>
+			<tr><th>Received</th><td>{{ .Held.Received.Format "2006-01-02 15:04:05" }}</td></tr>
+			<tr><th>Expires</th><td>{{ .Held.Expires.Format "2006-01-02 15:04:05" }}</td></tr>
+			<tr><th>Size</th><td>{{ .Held.Size }} bytes</td></tr>
+		</table>
+		<form method="POST">
+			<input type="hidden" name="token" value="{{ .Held.Token }}" />
+			<button type="submit" name="action" value="approve">Approve</button>
+			<button type="submit" name="action" value="reject">Reject</button>
+		</form>
+{{ end }}
+	</body>
+</html>
+`))
+
+// handleModerate serves the page for approving or rejecting a message held for a
+// moderated alias. Requests are authorized by the unguessable token from the link
+// sent to the alias owner, not by a login session.
+func handleModerate(ctx context.Context, log mlog.Log, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "POST" {
+		http.Error(w, "405 - method not allowed - get or post required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := r.FormValue("token")
+	h, err := moderation.Get(ctx, token)
+	if err != nil && errors.Is(err, admindb.ErrNotFound) {
+		http.Error(w, "404 - not found - unknown token, message may have been approved, rejected or expired", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Errorx("looking up held message", err)
+		http.Error(w, "500 - internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	var result string
+	if r.Method == "POST" {
+		switch action := r.FormValue("action"); action {
+		case "approve":
+			err = moderation.Approve(ctx, log, token)
+			result = "Message approved and delivered to the members."
+		case "reject":
+			err = moderation.Reject(ctx, log, token)
+			result = "Message rejected and removed."
+		default:
+			http.Error(w, "400 - bad request - unknown action", http.StatusBadRequest)
+			return
+		}
+		if err != nil && errors.Is(err, admindb.ErrNotFound) {
+			http.Error(w, "404 - not found - message may have been approved, rejected or expired", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Errorx("moderating held message", err, slog.Int64("id", h.ID))
+			http.Error(w, "500 - internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	err = moderateTemplate.Execute(w, struct {
+		Held   admindb.ModerationHeld
+		Result string
+	}{h, result})
+	log.Check(err, "executing moderation template")
+}
@@ -19,6 +19,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net"
 	"net/http"
@@ -45,6 +46,10 @@ import (
 	"github.com/mjl-/sherpadoc"
 	"github.com/mjl-/sherpaprom"
 
+	"github.com/mjl-/mox/accountdel"
+	"github.com/mjl-/mox/accounting"
+	"github.com/mjl-/mox/admindb"
+	"github.com/mjl-/mox/autotls"
 	"github.com/mjl-/mox/config"
 	"github.com/mjl-/mox/dkim"
 	"github.com/mjl-/mox/dmarc"
@@ -52,13 +57,16 @@ import (
 	"github.com/mjl-/mox/dmarcrpt"
 	"github.com/mjl-/mox/dns"
 	"github.com/mjl-/mox/dnsbl"
+	"github.com/mjl-/mox/i18n"
 	"github.com/mjl-/mox/metrics"
 	"github.com/mjl-/mox/mlog"
 	mox "github.com/mjl-/mox/mox-"
 	"github.com/mjl-/mox/moxvar"
 	"github.com/mjl-/mox/mtasts"
 	"github.com/mjl-/mox/mtastsdb"
+	"github.com/mjl-/mox/mtastspromote"
 	"github.com/mjl-/mox/publicsuffix"
+	"github.com/mjl-/mox/quarantine"
 	"github.com/mjl-/mox/queue"
 	"github.com/mjl-/mox/smtp"
 	"github.com/mjl-/mox/spf"
@@ -66,6 +74,7 @@ import (
 	"github.com/mjl-/mox/tlsrpt"
 	"github.com/mjl-/mox/tlsrptdb"
 	"github.com/mjl-/mox/webauth"
+	"github.com/mjl-/mox/webops"
 )
 
 var pkglog = mlog.New("webadmin", nil)
@@ -143,6 +152,7 @@ var requestInfoCtxKey ctxKey = "requestInfo"
 
 type requestInfo struct {
 	SessionToken store.SessionToken
+	LoginAddress string // Empty for the server admin, set for domain admins.
 	Response     http.ResponseWriter
 	Request      *http.Request // For Proto and TLS connection state during message submit.
 }
@@ -152,7 +162,10 @@ func handle(apiHandler http.Handler, isForwarded bool, w http.ResponseWriter, r
 	log := pkglog.WithContext(ctx).With(slog.String("adminauth", ""))
 
 	// HTML/JS can be retrieved without authentication.
-	if r.URL.Path == "/" {
+	if r.URL.Path == "/i18n.json" {
+		i18n.ServeJSON(log, w, r)
+		return
+	} else if r.URL.Path == "/" {
 		switch r.Method {
 		case "GET", "HEAD":
 			webadminFile.Serve(ctx, log, w, r)
@@ -171,9 +184,11 @@ func handle(apiHandler http.Handler, isForwarded bool, w http.ResponseWriter, r
 
 	// All other URLs, except the login endpoint require some authentication.
 	var sessionToken store.SessionToken
-	if r.URL.Path != "/api/LoginPrep" && r.URL.Path != "/api/Login" {
+	var loginAddress string
+	isTakeout := r.URL.Path == "/takeout"
+	if r.URL.Path != "/api/LoginPrep" && r.URL.Path != "/api/Login" && r.URL.Path != "/api/PasskeyLoginPrep" && r.URL.Path != "/api/PasskeyLogin" && r.URL.Path != "/api/OIDCEnabled" && r.URL.Path != "/api/OIDCLoginPrep" && r.URL.Path != "/api/OIDCLogin" {
 		var ok bool
-		_, sessionToken, _, ok = webauth.Check(ctx, log, webauth.Admin, "webadmin", isForwarded, w, r, isAPI, isAPI, false)
+		_, sessionToken, loginAddress, ok = webauth.Check(ctx, log, webauth.Admin, "webadmin", isForwarded, w, r, isAPI, isAPI || isTakeout, isTakeout)
 		if !ok {
 			// Response has been written already.
 			return
@@ -181,15 +196,190 @@ func handle(apiHandler http.Handler, isForwarded bool, w http.ResponseWriter, r
 	}
 
 	if isAPI {
-		reqInfo := requestInfo{sessionToken, w, r}
+		method := strings.TrimPrefix(r.URL.Path, "/api/")
+		if !auditSkip[method] && r.Method == "POST" {
+			aw := &auditWriter{ResponseWriter: w}
+			w = aw
+			body := &capBuffer{max: 64 * 1024}
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.TeeReader(r.Body, body), r.Body}
+			defer func() {
+				actor := loginAddress
+				if actor == "" {
+					actor = "admin"
+				}
+				e := admindb.AuditEntry{
+					Source:   "webadmin",
+					Actor:    actor,
+					RemoteIP: webauth.RemoteIP(log, isForwarded, r).String(),
+					Action:   method,
+					Params:   auditParams(method, body.buf.Bytes()),
+					Error:    aw.errorMessage(),
+				}
+				err := admindb.AuditAdd(context.WithoutCancel(ctx), &e)
+				log.Check(err, "adding admin action to audit log")
+			}()
+		}
+
+		// Domain admins can only manage their own domains.
+		if loginAddress != "" && r.Method == "POST" {
+			buf, err := io.ReadAll(r.Body)
+			if err == nil {
+				err = domainAdminCheck(method, buf, mox.Conf.DomainAdminDomains(loginAddress))
+			}
+			if err != nil {
+				log.Infox("domain admin request denied", err, slog.String("loginaddress", loginAddress), slog.String("method", method))
+				w.Header().Set("Content-Type", "application/json; charset=utf-8")
+				var result = struct {
+					Error sherpa.Error `json:"error"`
+				}{
+					sherpa.Error{Code: "user:error", Message: "not allowed for domain admin: " + err.Error()},
+				}
+				err := json.NewEncoder(w).Encode(result)
+				log.Check(err, "writing error response")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(buf))
+		}
+
+		reqInfo := requestInfo{sessionToken, loginAddress, w, r}
 		ctx = context.WithValue(ctx, requestInfoCtxKey, reqInfo)
 		apiHandler.ServeHTTP(w, r.WithContext(ctx))
 		return
 	}
 
+	if isTakeout && r.Method == "POST" {
+		accName := r.PostFormValue("account")
+		acc, ok := mox.Conf.Account(accName)
+		if !ok {
+			http.Error(w, "404 - not found - no such account", http.StatusNotFound)
+			return
+		} else if loginAddress != "" && !slices.Contains(mox.Conf.DomainAdminDomains(loginAddress), acc.DNSDomain) {
+			http.Error(w, "403 - forbidden - account not managed by domain admin", http.StatusForbidden)
+			return
+		}
+		actor := loginAddress
+		if actor == "" {
+			actor = "admin"
+		}
+		params, err := json.Marshal([]string{accName})
+		log.Check(err, "marshal takeout parameters for audit log")
+		e := admindb.AuditEntry{
+			Source:   "webadmin",
+			Actor:    actor,
+			RemoteIP: webauth.RemoteIP(log, isForwarded, r).String(),
+			Action:   "Takeout",
+			Params:   string(params),
+		}
+		err = admindb.AuditAdd(context.WithoutCancel(ctx), &e)
+		log.Check(err, "adding takeout to audit log")
+		webops.Takeout(log, accName, w, r)
+		return
+	}
+
 	http.NotFound(w, r)
 }
 
+// auditSkip are the API methods that don't make changes, or are about the admin
+// session itself. Calls to all other methods are added to the audit log.
+var auditSkip = map[string]bool{}
+
+func init() {
+	for _, s := range strings.Fields(`
+LoginPrep Login Logout PasskeyLoginPrep PasskeyLogin OIDCEnabled OIDCLoginPrep OIDCLogin
+CheckDomain Domains Domain ParseDomain DomainConfig DomainLocalparts Accounts Account ConfigFiles
+MTASTSPolicies DomainMTASTSPromotion TLSReports TLSReportID TLSRPTSummaries DMARCReports DMARCReportID DMARCSummaries
+LookupIP DNSBLStatus DomainRecords AccountProtocolSessions AccountPasskeys Passkeys PasskeyRegisterPrep
+ClientConfigsDomain QueueSize QueueHoldRuleList QueueList RetiredList HookQueueSize HookList HookRetiredList
+LogLevels CheckUpdatesEnabled WebserverConfig Transports DMARCEvaluationStats DMARCEvaluationsDomain
+DMARCSuppressList TLSRPTResults TLSRPTResultsDomain LookupTLSRPTRecord TLSRPTSuppressList LookupCid Config
+APITokens AuditList AdminScope AccountDeletions SubmissionIncidents Quarantined QuarantineHeaders
+SpamtrapHits LogRecent MessageTrace ConfigReloadPreview UsageList AbuseReports LineEndingSources AutoBans
+AccountPasswordChangeRequired DomainSetups DomainSetupCheck
+`) {
+		auditSkip[s] = true
+	}
+}
+
+// auditRedact are parameters, by method and index, not stored in the audit log.
+var auditRedact = map[string]int{
+	"SetPassword":          1,
+	"PasskeyRegister":      1,
+	"AccountDeletionPurge": 0, // The purge redacts earlier references to the account.
+}
+
+// auditParams returns the parameters from a sherpa request body for the audit log.
+func auditParams(method string, body []byte) string {
+	var req struct {
+		Params []json.RawMessage `json:"params"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return ""
+	}
+	if i, ok := auditRedact[method]; ok && i < len(req.Params) {
+		req.Params[i] = json.RawMessage(`"***"`)
+	}
+	buf, err := json.Marshal(req.Params)
+	if err != nil {
+		return ""
+	}
+	const maxSize = 1024
+	if len(buf) > maxSize {
+		return string(buf[:maxSize]) + "..."
+	}
+	return string(buf)
+}
+
+// capBuffer stores the first max bytes written to it, and discards the rest.
+type capBuffer struct {
+	buf bytes.Buffer
+	max int
+}
+
+func (b *capBuffer) Write(p []byte) (int, error) {
+	if n := b.max - b.buf.Len(); n > 0 {
+		b.buf.Write(p[:min(n, len(p))])
+	}
+	return len(p), nil
+}
+
+// auditWriter keeps the start of a sherpa response, for the error in the audit log.
+type auditWriter struct {
+	http.ResponseWriter
+	status int
+	resp   capBuffer
+}
+
+func (w *auditWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *auditWriter) Write(buf []byte) (int, error) {
+	if w.resp.max == 0 {
+		w.resp.max = 4 * 1024
+	}
+	w.resp.Write(buf)
+	return w.ResponseWriter.Write(buf)
+}
+
+func (w *auditWriter) errorMessage() string {
+	var resp struct {
+		Error *sherpa.Error `json:"error"`
+	}
+	if err := json.Unmarshal(w.resp.buf.Bytes(), &resp); err == nil && resp.Error != nil {
+		return resp.Error.Message
+	}
+	if w.status != 0 && w.status != http.StatusOK {
+		return fmt.Sprintf("http status %d", w.status)
+	}
+	return ""
+}
+
 func xcheckf(ctx context.Context, err error, format string, args ...any) {
 	if err == nil {
 		return
@@ -242,12 +432,17 @@ func (w Admin) LoginPrep(ctx context.Context) string {
 }
 
 // Login returns a session token for the credentials, or fails with error code
-// "user:badLogin". Call LoginPrep to get a loginToken.
-func (w Admin) Login(ctx context.Context, loginToken, password string) store.CSRFToken {
+// "user:badLogin". Call LoginPrep to get a loginToken. If two-factor
+// authentication is enabled and totpCode is empty, the error code is
+// "user:totpRequired", and the login must be retried with a code.
+//
+// The server admin logs in with an empty username and the admin password. Domain
+// admins log in with the login address and password of their account.
+func (w Admin) Login(ctx context.Context, loginToken, username, password, totpCode string) store.CSRFToken {
 	log := pkglog.WithContext(ctx)
 	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
 
-	csrfToken, err := webauth.Login(ctx, log, webauth.Admin, "webadmin", w.cookiePath, w.isForwarded, reqInfo.Response, reqInfo.Request, loginToken, "", password)
+	csrfToken, err := webauth.Login(ctx, log, webauth.Admin, "webadmin", w.cookiePath, w.isForwarded, reqInfo.Response, reqInfo.Request, loginToken, username, password, totpCode)
 	if _, ok := err.(*sherpa.Error); ok {
 		panic(err)
 	}
@@ -255,6 +450,64 @@ func (w Admin) Login(ctx context.Context, loginToken, password string) store.CSR
 	return csrfToken
 }
 
+// PasskeyLoginPrep returns options for logging in with a passkey with
+// navigator.credentials.get in the browser.
+func (w Admin) PasskeyLoginPrep(ctx context.Context) webauth.PasskeyRequestOptions {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	return webauth.PasskeyLoginPrep("webadmin", w.isForwarded, reqInfo.Request)
+}
+
+// PasskeyLogin returns a session token for a login with a passkey, started with
+// PasskeyLoginPrep, or fails with error code "user:loginFailed". Call LoginPrep
+// to get a loginToken after the user selected a passkey.
+func (w Admin) PasskeyLogin(ctx context.Context, loginToken string, response webauth.PasskeyAssertion) store.CSRFToken {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	csrfToken, err := webauth.PasskeyLogin(ctx, log, webauth.Admin, "webadmin", w.cookiePath, w.isForwarded, reqInfo.Response, reqInfo.Request, loginToken, response)
+	if _, ok := err.(*sherpa.Error); ok {
+		panic(err)
+	}
+	xcheckf(ctx, err, "passkey login")
+	return csrfToken
+}
+
+// OIDCEnabled returns whether single sign-on through an OpenID Connect identity
+// provider is configured.
+func (Admin) OIDCEnabled(ctx context.Context) bool {
+	return webauth.OIDCEnabled()
+}
+
+// OIDCLoginPrep starts a single sign-on login, returning the URL of the identity
+// provider to navigate to. The identity provider sends the browser back to this
+// page with "code" and "state" query string parameters, for OIDCLogin.
+func (w Admin) OIDCLoginPrep(ctx context.Context) string {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	authURL, err := webauth.OIDCLoginPrep(ctx, log, "webadmin", w.cookiePath, w.isForwarded, reqInfo.Response, reqInfo.Request)
+	if _, ok := err.(*sherpa.Error); ok {
+		panic(err)
+	}
+	xcheckf(ctx, err, "starting single sign-on")
+	return authURL
+}
+
+// OIDCLogin returns a session token for a single sign-on login started with
+// OIDCLoginPrep, or fails with error code "user:loginFailed". Call LoginPrep to
+// get a loginToken.
+func (w Admin) OIDCLogin(ctx context.Context, loginToken, code, state string) store.CSRFToken {
+	log := pkglog.WithContext(ctx)
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	csrfToken, err := webauth.OIDCLogin(ctx, log, webauth.Admin, "webadmin", w.cookiePath, w.isForwarded, reqInfo.Response, reqInfo.Request, loginToken, code, state)
+	if _, ok := err.(*sherpa.Error); ok {
+		panic(err)
+	}
+	xcheckf(ctx, err, "single sign-on login")
+	return csrfToken
+}
+
 // Logout invalidates the session token.
 func (w Admin) Logout(ctx context.Context) {
 	log := pkglog.WithContext(ctx)
@@ -430,7 +683,11 @@ func (Admin) CheckDomain(ctx context.Context, domainName string) (r CheckResult)
 	dialer := &net.Dialer{Timeout: 10 * time.Second}
 	nctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
-	return checkDomain(nctx, resolver, dialer, domainName)
+	r = checkDomain(nctx, resolver, dialer, domainName)
+	if d, err := dns.ParseDomain(domainName); err == nil {
+		dnscheckMetricsUpdate(d, r)
+	}
+	return r
 }
 
 func unptr[T any](l []*T) []T {
@@ -924,7 +1181,7 @@ EOF
 			for _, r := range records {
 				instr += fmt.Sprintf("\t_25._tcp.%s. TLSA %s\n", pubDom.ASCII, r)
 			}
-			addf(&r.DANE.Instructions, instr)
+			addf(&r.DANE.Instructions, "%s", instr)
 		}
 	}()
 
@@ -1175,9 +1432,9 @@ EOF
 			addf(&r.DMARC.Instructions, `Configure a DMARC destination in domain in config file.`)
 		}
 		instr := fmt.Sprintf("Ensure a DNS TXT record like the following exists:\n\n\t_dmarc TXT %s\n\nYou can start with testing mode by replacing p=reject with p=none. You can also request for the policy to be applied to a percentage of emails instead of all, by adding pct=X, with X between 0 and 100. Keep in mind that receiving mail servers will apply some anti-spam assessment regardless of the policy and whether it is applied to the message. The ruf= part requests daily aggregate reports to be sent to the specified address, which is automatically configured and reports automatically analyzed.", mox.TXTStrings(dmarcr.String()))
-		addf(&r.DMARC.Instructions, instr)
+		addf(&r.DMARC.Instructions, "%s", instr)
 		if extInstr != "" {
-			addf(&r.DMARC.Instructions, extInstr)
+			addf(&r.DMARC.Instructions, "%s", extInstr)
 		}
 	}()
 
@@ -1236,7 +1493,7 @@ Ensure a DNS TXT record like the following exists:
 		} else {
 			addf(&result.Errors, `Configure a domain TLSRPT destination in domains.conf config file.`)
 		}
-		addf(&result.Instructions, instr)
+		addf(&result.Instructions, "%s", instr)
 	}
 
 	// Host TLSRPT
@@ -1276,7 +1533,7 @@ Ensure a DNS TXT record like the following exists:
 		} else if policy.Mode == mtasts.ModeNone {
 			addf(&r.MTASTS.Warnings, "MTA-STS policy is present, but does not require TLS.")
 		} else if policy.Mode == mtasts.ModeTesting {
-			addf(&r.MTASTS.Warnings, "MTA-STS policy is in testing mode, do not forget to change to mode enforce after testing period.")
+			addf(&r.MTASTS.Warnings, "MTA-STS policy is in testing mode, do not forget to change to mode enforce after testing period, or configure automatic promotion to mode enforce.")
 		}
 		r.MTASTS.PolicyText = text
 		r.MTASTS.Policy = policy
@@ -1309,7 +1566,7 @@ Ensure a DNS TXT record like the following exists:
 					continue
 				}
 				if _, ok := mxs[mx.Domain]; !ok {
-					addf(&r.MTASTS.Warnings, "MX %q in MTA-STS policy is not in MX record.", mx)
+					addf(&r.MTASTS.Warnings, "MX %q in MTA-STS policy is not in MX record.", mx.LogString())
 				}
 			}
 		}
@@ -1326,19 +1583,19 @@ The _mta-sts DNS TXT record has an "id" field. The id serves as a version of the
 
 When enabling MTA-STS, or updating a policy, always update the policy first (through a configuration change and reload/restart), and the DNS record second.
 `
-		addf(&r.MTASTS.Instructions, intro)
+		addf(&r.MTASTS.Instructions, "%s", intro)
 
 		addf(&r.MTASTS.Instructions, `Enable a policy through the configuration file. For new deployments, it is best to start with mode "testing" while enabling TLSRPT. Start with a short "max_age", so updates to your policy are picked up quickly. When confidence in the deployment is high enough, switch to "enforce" mode and a longer "max age". A max age in the order of weeks is recommended. If you foresee a change to your setup in the future, requiring different policies or MX records, you may want to dial back the "max age" ahead of time, similar to how you would handle TTL's in DNS record updates.`)
 
 		host := fmt.Sprintf("Ensure DNS CNAME/A/AAAA records exist that resolve mta-sts.%s to this mail server. For example:\n\n\t%s CNAME %s\n\n", domain.ASCII, "mta-sts."+domain.ASCII+".", mox.Conf.Static.HostnameDomain.ASCII+".")
-		addf(&r.MTASTS.Instructions, host)
+		addf(&r.MTASTS.Instructions, "%s", host)
 
 		mtastsr := mtasts.Record{
 			Version: "STSv1",
 			ID:      time.Now().Format("20060102T150405"),
 		}
 		dns := fmt.Sprintf("Ensure a DNS TXT record like the following exists:\n\n\t_mta-sts TXT %s\n\nConfigure the ID in the configuration file, it must be of the form [a-zA-Z0-9]{1,31}. It represents the version of the policy. For each policy change, you must change the ID to a new unique value. You could use a timestamp like 20220621T123000. When this field exists, an SMTP server will fetch a policy at https://mta-sts.%s/.well-known/mta-sts.txt. This policy is served by mox.", mox.TXTStrings(mtastsr.String()), domain.Name())
-		addf(&r.MTASTS.Instructions, dns)
+		addf(&r.MTASTS.Instructions, "%s", dns)
 	}()
 
 	// SRVConf
@@ -1404,7 +1661,7 @@ When enabling MTA-STS, or updating a policy, always update the policy first (thr
 				addf(&r.SRVConf.Errors, "Unexpected SRV record(s) for %q", name)
 			}
 		}
-		addf(&r.SRVConf.Instructions, instr)
+		addf(&r.SRVConf.Instructions, "%s", instr)
 	}()
 
 	// Autoconf
@@ -1495,8 +1752,12 @@ When enabling MTA-STS, or updating a policy, always update the policy first (thr
 	return
 }
 
-// Domains returns all configured domain names, in UTF-8 for IDNA domains.
+// Domains returns all configured domain names, in UTF-8 for IDNA domains. For
+// domain admins, only their domains are returned.
 func (Admin) Domains(ctx context.Context) []dns.Domain {
+	if domains, ok := domainAdminDomains(ctx); ok {
+		return domains
+	}
 	l := []dns.Domain{}
 	for _, s := range mox.Conf.Domains() {
 		d, _ := dns.ParseDomain(s)
@@ -1545,9 +1806,16 @@ func (Admin) DomainLocalparts(ctx context.Context, domain string) (localpartAcco
 	return mox.Conf.DomainLocalparts(d)
 }
 
-// Accounts returns the names of all configured accounts.
+// Accounts returns the names of all configured accounts. For domain admins, only
+// accounts with a default domain they manage are returned.
 func (Admin) Accounts(ctx context.Context) []string {
 	l := mox.Conf.Accounts()
+	if domains, ok := domainAdminDomains(ctx); ok {
+		l = slices.DeleteFunc(l, func(name string) bool {
+			acc, ok := mox.Conf.Account(name)
+			return !ok || !slices.Contains(domains, acc.DNSDomain)
+		})
+	}
 	sort.Slice(l, func(i, j int) bool {
 		return l[i] < l[j]
 	})
@@ -1600,6 +1868,27 @@ func (Admin) MTASTSPolicies(ctx context.Context) (records []mtastsdb.PolicyRecor
 	return records
 }
 
+// ACMECertificates returns the certificates obtained through ACME, for all ACME
+// providers, with the provider and certificate authority that issued them.
+func (Admin) ACMECertificates(ctx context.Context) (certs []autotls.CertificateInfo) {
+	var names []string
+	for name := range mox.Conf.Static.ACME {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	certs = []autotls.CertificateInfo{}
+	for _, name := range names {
+		m := mox.Conf.Static.ACME[name].Manager
+		if m == nil {
+			continue
+		}
+		l, err := m.Certificates(ctx)
+		xcheckf(ctx, err, "listing certificates for acme provider %s", name)
+		certs = append(certs, l...)
+	}
+	return certs
+}
+
 // TLSReports returns TLS reports overlapping with period start/end, for the given
 // policy domain (or all domains if empty). The reports are sorted first by period
 // end (most recent first), then by policy domain.
@@ -1929,6 +2218,9 @@ func (Admin) DomainRemove(ctx context.Context, domain string) {
 
 	err = mox.DomainRemove(ctx, d)
 	xcheckf(ctx, err, "removing domain")
+
+	err = admindb.DomainSetupRemove(ctx, d.Name())
+	xcheckf(ctx, err, "removing domain setup")
 }
 
 // AccountAdd adds existing a new account, with an initial email address, and
@@ -1958,12 +2250,14 @@ func (Admin) AddressRemove(ctx context.Context, address string) {
 
 // SetPassword saves a new password for an account, invalidating the previous password.
 // Sessions are not interrupted, and will keep working. New login attempts must use the new password.
-// Password must be at least 8 characters.
+// Password must be at least 8 characters, and meet the password policy.
 func (Admin) SetPassword(ctx context.Context, accountName, password string) {
 	log := pkglog.WithContext(ctx)
-	if len(password) < 8 {
-		xusererrorf(ctx, "message must be at least 8 characters")
+	err := mox.PasswordPolicyCheck(ctx, log, accountName, password)
+	if errors.Is(err, mox.ErrPasswordPolicy) {
+		xcheckuserf(ctx, err, "checking password")
 	}
+	xcheckf(ctx, err, "checking password")
 	acc, err := store.OpenAccount(log, accountName)
 	xcheckf(ctx, err, "open account")
 	defer func() {
@@ -1974,17 +2268,437 @@ func (Admin) SetPassword(ctx context.Context, accountName, password string) {
 	xcheckf(ctx, err, "setting password")
 }
 
+// AccountPasswordChangeRequired returns whether the user of the account must
+// change the password.
+func (Admin) AccountPasswordChangeRequired(ctx context.Context, accountName string) bool {
+	log := pkglog.WithContext(ctx)
+	acc, err := store.OpenAccount(log, accountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+	required, err := acc.PasswordChangeRequired(ctx)
+	xcheckf(ctx, err, "get password status")
+	return required
+}
+
+// AccountPasswordChangeRequire sets whether the user of the account must change
+// the password, e.g. after it may have been compromised. Until then, the
+// password is only accepted for the account web interface, and its web sessions
+// are ended. App passwords keep working.
+func (Admin) AccountPasswordChangeRequire(ctx context.Context, accountName string, required bool) {
+	log := pkglog.WithContext(ctx)
+	acc, err := store.OpenAccount(log, accountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+	err = acc.PasswordChangeRequire(ctx, log, required)
+	if errors.Is(err, store.ErrNoPassword) {
+		xcheckuserf(ctx, err, "setting password change requirement")
+	}
+	xcheckf(ctx, err, "setting password change requirement")
+}
+
+// AccountProtocolSessions returns the active and recent IMAP and SMTP submission
+// sessions of an account.
+func (Admin) AccountProtocolSessions(ctx context.Context, accountName string) []store.ProtocolSession {
+	log := pkglog.WithContext(ctx)
+	acc, err := store.OpenAccount(log, accountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+	l, err := acc.ProtocolSessions(ctx)
+	xcheckf(ctx, err, "listing sessions")
+	return l
+}
+
+// AccountProtocolSessionClose closes an active IMAP or SMTP submission session
+// of an account. If id is 0, all active sessions of the account are closed.
+func (Admin) AccountProtocolSessionClose(ctx context.Context, accountName string, id int64) (closed int) {
+	log := pkglog.WithContext(ctx)
+	acc, err := store.OpenAccount(log, accountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+	closed, err = acc.ProtocolSessionClose(log, id)
+	if errors.Is(err, store.ErrSessionUnknown) {
+		xcheckuserf(ctx, err, "closing session")
+	}
+	xcheckf(ctx, err, "closing session")
+	return closed
+}
+
+// AccountTOTPReset disables two-factor authentication for an account, e.g. when
+// the user lost access to the authenticator app and recovery codes. The user can
+// then log in with just the password, and set up two-factor authentication again.
+func (Admin) AccountTOTPReset(ctx context.Context, accountName string) {
+	log := pkglog.WithContext(ctx)
+	acc, err := store.OpenAccount(log, accountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+	err = acc.TOTPDisable(ctx)
+	xcheckf(ctx, err, "resetting two-factor authentication")
+}
+
+// AccountPasskeys returns the passkeys of an account.
+func (Admin) AccountPasskeys(ctx context.Context, accountName string) []store.Passkey {
+	log := pkglog.WithContext(ctx)
+	acc, err := store.OpenAccount(log, accountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+	l, err := acc.Passkeys(ctx)
+	xcheckf(ctx, err, "listing passkeys")
+	return l
+}
+
+// AccountPasskeysReset removes all passkeys of an account, e.g. when the user
+// lost access to devices.
+func (Admin) AccountPasskeysReset(ctx context.Context, accountName string) (removed int) {
+	log := pkglog.WithContext(ctx)
+	acc, err := store.OpenAccount(log, accountName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+	removed, err = acc.PasskeysReset(ctx)
+	xcheckf(ctx, err, "removing passkeys")
+	return removed
+}
+
+// AccountDeletions returns the accounts with a pending deletion.
+func (Admin) AccountDeletions(ctx context.Context) []admindb.AccountDeletion {
+	l, err := admindb.DeletionList(ctx)
+	xcheckf(ctx, err, "listing pending account deletions")
+	if domains, ok := domainAdminDomains(ctx); ok {
+		l = slices.DeleteFunc(l, func(d admindb.AccountDeletion) bool {
+			acc, ok := mox.Conf.Account(d.Account)
+			return !ok || !slices.Contains(domains, acc.DNSDomain)
+		})
+	}
+	return l
+}
+
+// AccountDeletionRequest requests deletion of an account, disabling logins
+// immediately, and purging the account and all its data after the grace period.
+func (Admin) AccountDeletionRequest(ctx context.Context, accountName string, grace time.Duration) admindb.AccountDeletion {
+	log := pkglog.WithContext(ctx)
+	d, err := accountdel.Request(ctx, log, accountName, "webadmin", grace)
+	if errors.Is(err, mox.ErrRequest) || errors.Is(err, admindb.ErrExists) {
+		xcheckuserf(ctx, err, "requesting account deletion")
+	}
+	xcheckf(ctx, err, "requesting account deletion")
+	return d
+}
+
+// AccountDeletionCancel cancels a pending deletion of an account, enabling logins
+// again.
+func (Admin) AccountDeletionCancel(ctx context.Context, accountName string) {
+	log := pkglog.WithContext(ctx)
+	err := accountdel.Cancel(ctx, log, accountName)
+	if errors.Is(err, admindb.ErrNotFound) {
+		xcheckuserf(ctx, err, "canceling account deletion")
+	}
+	xcheckf(ctx, err, "canceling account deletion")
+}
+
+// AccountDeletionPurge purges an account with a pending deletion now, without
+// waiting for the grace period to end.
+func (Admin) AccountDeletionPurge(ctx context.Context, accountName string) {
+	log := pkglog.WithContext(ctx)
+	_, err := accountdel.Purge(ctx, log, accountName)
+	if errors.Is(err, admindb.ErrNotFound) {
+		xcheckuserf(ctx, err, "purging account")
+	}
+	xcheckf(ctx, err, "purging account")
+}
+
+// SubmissionIncidents returns the most recent anomalies detected in submissions
+// by an account, most recent first.
+func (Admin) SubmissionIncidents(ctx context.Context, accountName string, max int) []admindb.SubmissionIncident {
+	l, err := admindb.SubmissionIncidentList(ctx, accountName, max)
+	xcheckf(ctx, err, "listing submission incidents")
+	return l
+}
+
+// SubmissionThrottleClear ends throttling of submissions for an account before
+// the throttle duration has passed. It returns the number of throttles cleared.
+func (Admin) SubmissionThrottleClear(ctx context.Context, accountName string) int {
+	n, err := admindb.SubmissionThrottleClear(ctx, accountName)
+	xcheckf(ctx, err, "clearing submission throttle")
+	return n
+}
+
+// SpamtrapHits returns the most recent messages sent to spamtrap addresses, most
+// recent first, at most max if max > 0. Domain admins only see hits for spamtraps
+// in their domains.
+func (Admin) SpamtrapHits(ctx context.Context, max int) []admindb.SpamtrapHit {
+	l, err := admindb.SpamtrapHitList(ctx, max)
+	xcheckf(ctx, err, "listing spamtrap hits")
+	if domains, ok := domainAdminDomains(ctx); ok {
+		l = slices.DeleteFunc(l, func(h admindb.SpamtrapHit) bool {
+			addr, err := smtp.ParseAddress(h.Trap)
+			return err != nil || !slices.Contains(domains, addr.Domain)
+		})
+	}
+	return l
+}
+
+// AbuseReports returns the most recent abuse reports received at role addresses,
+// most recent first, at most max if max > 0.
+func (Admin) AbuseReports(ctx context.Context, max int) []admindb.AbuseReport {
+	l, err := admindb.AbuseReportList(ctx, max)
+	xcheckf(ctx, err, "listing abuse reports")
+	return l
+}
+
+// AbuseReportSetHandled marks an abuse report as handled, or as needing attention
+// again.
+func (Admin) AbuseReportSetHandled(ctx context.Context, reportID int64, handled bool) {
+	err := admindb.AbuseReportSetHandled(ctx, reportID, handled)
+	if errors.Is(err, admindb.ErrNotFound) {
+		xcheckuserf(ctx, err, "marking abuse report")
+	}
+	xcheckf(ctx, err, "marking abuse report")
+}
+
+// LineEndingSources returns the remote IPs that sent messages with line ending
+// anomalies in the SMTP message data, most recent first, at most max if max > 0.
+func (Admin) LineEndingSources(ctx context.Context, max int) []admindb.LineEndingSource {
+	l, err := admindb.LineEndingSourceList(ctx, max)
+	xcheckf(ctx, err, "listing line ending anomaly sources")
+	return l
+}
+
+// AutoBans returns the networks currently banned after many failed authentication
+// attempts or protocol abuses, most recent first.
+func (Admin) AutoBans(ctx context.Context) []mox.AutoBanEntry {
+	return mox.AutoBans()
+}
+
+// AutoBanRemove removes the ban for a network, allowing connections from it again.
+func (Admin) AutoBanRemove(ctx context.Context, network string) {
+	err := mox.AutoBanRemove(pkglog.WithContext(ctx), network)
+	if errors.Is(err, mox.ErrAutoBanNotFound) {
+		xcheckuserf(ctx, err, "removing ban")
+	}
+	xcheckf(ctx, err, "removing ban")
+}
+
+// MessageTrace returns the delivery history of a message, oldest first. The id is
+// a Message-ID, with or without <>, or the ID of a message in the queue.
+func (Admin) MessageTrace(ctx context.Context, id string) []admindb.MessageEvent {
+	id = strings.TrimSpace(id)
+	if id == "" {
+		xcheckuserf(ctx, errors.New("empty"), "checking id")
+	}
+	l, err := admindb.MessageEventList(ctx, id)
+	xcheckf(ctx, err, "listing message events")
+	return l
+}
+
+// UsageList returns the monthly usage of accounts and domains: messages
+// received and sent, their total sizes, and the highest total size of stored
+// messages. Start and end are months like "2026-10", or empty. Group is empty for
+// usage per month, account and domain, "account" for sums per month and account,
+// or "domain" for sums per month and domain.
+func (Admin) UsageList(ctx context.Context, start, end, account, domain, group string) []admindb.Usage {
+	l, err := accounting.List(ctx, admindb.UsageFilter{Start: start, End: end, Account: account, Domain: domain}, group)
+	xcheckf(ctx, err, "listing usage")
+	return l
+}
+
+// Quarantined returns the messages held in quarantine, most recent first,
+// optionally only for an account.
+func (Admin) Quarantined(ctx context.Context, accountName string) []admindb.Quarantined {
+	l, err := admindb.QuarantineList(ctx, accountName)
+	xcheckf(ctx, err, "listing quarantined messages")
+	if domains, ok := domainAdminDomains(ctx); ok {
+		l = slices.DeleteFunc(l, func(qm admindb.Quarantined) bool {
+			return !xdomainAdminAccount(domains, qm.Account)
+		})
+	}
+	return l
+}
+
+// xquarantineAccess checks that a domain admin manages the account of a
+// quarantined message.
+func xquarantineAccess(ctx context.Context, id int64) {
+	domains, ok := domainAdminDomains(ctx)
+	if !ok {
+		return
+	}
+	qm, err := admindb.QuarantineGet(ctx, id)
+	if errors.Is(err, admindb.ErrNotFound) {
+		xcheckuserf(ctx, err, "get quarantined message")
+	}
+	xcheckf(ctx, err, "get quarantined message")
+	if !xdomainAdminAccount(domains, qm.Account) {
+		xcheckuserf(ctx, errors.New("not allowed to manage account of message"), "checking access")
+	}
+}
+
+// xdomainAdminAccount returns whether the account is in one of the domains.
+func xdomainAdminAccount(domains []dns.Domain, account string) bool {
+	acc, ok := mox.Conf.Account(account)
+	return ok && slices.Contains(domains, acc.DNSDomain)
+}
+
+// QuarantineHeaders returns the header section of a quarantined message.
+func (Admin) QuarantineHeaders(ctx context.Context, id int64) string {
+	xquarantineAccess(ctx, id)
+	s, err := quarantine.Headers(ctx, "", id)
+	if errors.Is(err, admindb.ErrNotFound) {
+		xcheckuserf(ctx, err, "reading quarantined message")
+	}
+	xcheckf(ctx, err, "reading quarantined message")
+	return s
+}
+
+// QuarantineRelease delivers a quarantined message to the mailbox it was destined
+// for, and removes it from the quarantine.
+func (Admin) QuarantineRelease(ctx context.Context, id int64) {
+	log := pkglog.WithContext(ctx)
+	xquarantineAccess(ctx, id)
+	err := quarantine.Release(ctx, log, "", id)
+	if errors.Is(err, admindb.ErrNotFound) || errors.Is(err, store.ErrOverQuota) {
+		xcheckuserf(ctx, err, "releasing quarantined message")
+	}
+	xcheckf(ctx, err, "releasing quarantined message")
+}
+
+// QuarantineRemove removes a quarantined message without delivering it.
+func (Admin) QuarantineRemove(ctx context.Context, id int64) {
+	log := pkglog.WithContext(ctx)
+	xquarantineAccess(ctx, id)
+	err := quarantine.Remove(ctx, log, "", id)
+	if errors.Is(err, admindb.ErrNotFound) {
+		xcheckuserf(ctx, err, "removing quarantined message")
+	}
+	xcheckf(ctx, err, "removing quarantined message")
+}
+
+// Passkeys returns the passkeys for admin logins.
+func (Admin) Passkeys(ctx context.Context) []store.Passkey {
+	l, err := webauth.AdminPasskeys()
+	xcheckf(ctx, err, "listing admin passkeys")
+	return l
+}
+
+// PasskeyRegisterPrep returns options for registering a new passkey for admin
+// logins with navigator.credentials.create in the browser. Finish registration
+// with PasskeyRegister.
+func (w Admin) PasskeyRegisterPrep(ctx context.Context) webauth.PasskeyCreationOptions {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	exclude, err := webauth.AdminPasskeyCredentialIDs()
+	xcheckf(ctx, err, "listing admin passkeys")
+	return webauth.PasskeyRegisterPrep("webadmin", w.isForwarded, reqInfo.Request, "", webauth.AdminPasskeyUserID, "admin", exclude)
+}
+
+// PasskeyRegister finishes registration of an admin passkey started with
+// PasskeyRegisterPrep, with the response of navigator.credentials.create.
+func (Admin) PasskeyRegister(ctx context.Context, label string, response webauth.PasskeyAttestation) {
+	cred, err := webauth.PasskeyRegister("webadmin", "", response)
+	xcheckuserf(ctx, err, "verifying passkey registration")
+	err = webauth.AdminPasskeyAdd(label, cred)
+	if errors.Is(err, store.ErrPasskeyParam) {
+		xcheckuserf(ctx, err, "adding passkey")
+	}
+	xcheckf(ctx, err, "adding passkey")
+}
+
+// PasskeyRemove removes a passkey for admin logins.
+func (Admin) PasskeyRemove(ctx context.Context, id int64) {
+	err := webauth.AdminPasskeyRemove(id)
+	if errors.Is(err, store.ErrPasskeyParam) {
+		xcheckuserf(ctx, err, "removing passkey")
+	}
+	xcheckf(ctx, err, "removing passkey")
+}
+
+// APITokens returns the tokens for the admin API.
+func (Admin) APITokens(ctx context.Context) []admindb.APIToken {
+	l, err := admindb.TokenList(ctx)
+	xcheckf(ctx, err, "listing api tokens")
+	return l
+}
+
+// APITokenAdd adds a token for the admin API with a role, and returns the token.
+// The token is not stored, only a hash.
+func (Admin) APITokenAdd(ctx context.Context, name string, role admindb.Role) string {
+	token, _, err := admindb.TokenAdd(ctx, name, role)
+	if errors.Is(err, admindb.ErrExists) || errors.Is(err, mox.ErrRequest) {
+		xcheckuserf(ctx, err, "adding api token")
+	}
+	xcheckf(ctx, err, "adding api token")
+	return token
+}
+
+// APITokenRemove removes a token for the admin API.
+func (Admin) APITokenRemove(ctx context.Context, name string) {
+	err := admindb.TokenRemove(ctx, name)
+	if errors.Is(err, admindb.ErrNotFound) {
+		xcheckuserf(ctx, err, "removing api token")
+	}
+	xcheckf(ctx, err, "removing api token")
+}
+
+// AuditList returns entries from the audit log of admin actions, most recent
+// first. If source is non-empty, only entries from that source are returned:
+// "webadmin", "adminapi" or "ctl". If max is 0, all entries are returned.
+func (Admin) AuditList(ctx context.Context, source string, max int) []admindb.AuditEntry {
+	l, err := admindb.AuditList(ctx, admindb.AuditFilter{Source: source, Max: max})
+	xcheckf(ctx, err, "listing audit log")
+	return l
+}
+
 // AccountSettingsSave set new settings for an account that only an admin can set.
-func (Admin) AccountSettingsSave(ctx context.Context, accountName string, maxOutgoingMessagesPerDay, maxFirstTimeRecipientsPerDay int, maxMsgSize int64, firstTimeSenderDelay bool) {
+func (Admin) AccountSettingsSave(ctx context.Context, accountName string, maxOutgoingMessagesPerDay, maxFirstTimeRecipientsPerDay, maxAliases int, maxMsgSize int64, firstTimeSenderDelay bool) {
+	if maxAliases < 0 {
+		xcheckuserf(ctx, errors.New("must be >= 0"), "checking maximum number of aliases")
+	}
 	err := mox.AccountSave(ctx, accountName, func(acc *config.Account) {
 		acc.MaxOutgoingMessagesPerDay = maxOutgoingMessagesPerDay
 		acc.MaxFirstTimeRecipientsPerDay = maxFirstTimeRecipientsPerDay
+		acc.MaxAliases = maxAliases
 		acc.QuotaMessageSize = maxMsgSize
 		acc.NoFirstTimeSenderDelay = !firstTimeSenderDelay
 	})
 	xcheckf(ctx, err, "saving account settings")
 }
 
+// AccountJunkFilterSharedSave saves whether the account contributes to the shared
+// junk filter, and the weight of the shared junk filter when classifying incoming
+// messages for the account.
+func (Admin) AccountJunkFilterSharedSave(ctx context.Context, accountName string, contribute bool, sharedWeight float64) {
+	if sharedWeight < 0 || sharedWeight > 1 {
+		xcheckuserf(ctx, errors.New("must be between 0 and 1"), "checking shared junk filter weight")
+	}
+	err := mox.AccountSave(ctx, accountName, func(acc *config.Account) {
+		if acc.JunkFilter == nil {
+			return
+		}
+		jf := *acc.JunkFilter
+		jf.ContributeShared = contribute
+		jf.SharedWeight = sharedWeight
+		acc.JunkFilter = &jf
+	})
+	xcheckf(ctx, err, "saving account shared junk filter settings")
+}
+
 // ClientConfigsDomain returns configurations for email clients, IMAP and
 // Submission (SMTP) for the domain.
 func (Admin) ClientConfigsDomain(ctx context.Context, domain string) mox.ClientConfigs {
@@ -2175,6 +2889,137 @@ func (Admin) LogLevelRemove(ctx context.Context, pkg string) {
 	mox.Conf.LogLevelRemove(pkglog.WithContext(ctx), pkg)
 }
 
+// LogLevelsReset resets the log levels to those in the config file, undoing
+// changes made at runtime. Also done on SIGHUP.
+func (Admin) LogLevelsReset(ctx context.Context) {
+	err := mox.Conf.LogLevelsReload(pkglog.WithContext(ctx))
+	xcheckf(ctx, err, "resetting log levels")
+}
+
+// ConfigReloadPreview returns the changes in the config file mox.conf compared to
+// the running configuration, without applying them.
+func (Admin) ConfigReloadPreview(ctx context.Context) mox.StaticReload {
+	r, err := mox.Conf.ReloadStatic(ctx, pkglog.WithContext(ctx), false)
+	xcheckuserf(ctx, err, "reading config file")
+	return r
+}
+
+// ConfigReload reloads the config file mox.conf, applying changes that can take
+// effect while running, and resetting log levels. Changes that require a restart
+// are returned but not applied. If the config file has errors, nothing is applied.
+// Also done on SIGHUP.
+func (Admin) ConfigReload(ctx context.Context) mox.StaticReload {
+	r, err := mox.Conf.ReloadStatic(ctx, pkglog.WithContext(ctx), true)
+	xcheckuserf(ctx, err, "reloading config file")
+	return r
+}
+
+// LogFilter filters recent log entries. Empty fields match all entries.
+type LogFilter struct {
+	Account   string // Matches the "account" attribute.
+	MessageID string // Matches the "messageid" attribute, with or without <>.
+	Cid       string // Connection ID, in hex, matches the "cid" attribute.
+	Pkg       string // Package that logged the entry.
+	Level     string // Minimum level, e.g. "info".
+	Text      string // Case-insensitive substring of message or attribute value.
+	Max       int    // Maximum number of entries returned. Default 1000.
+}
+
+// LogEntry is a recently logged line.
+type LogEntry struct {
+	Time    time.Time
+	Level   string
+	Pkg     string
+	Message string
+	Fields  []LogField
+}
+
+// LogField is an attribute of a log entry.
+type LogField struct {
+	Key   string
+	Value string
+}
+
+// LogRecent returns log entries kept in memory that match the filter, most
+// recent first.
+func (Admin) LogRecent(ctx context.Context, filter LogFilter) []LogEntry {
+	var minLevel slog.Level
+	if filter.Level != "" {
+		level, ok := mlog.Levels[filter.Level]
+		if !ok {
+			xcheckuserf(ctx, errors.New("unknown"), "lookup level")
+		}
+		minLevel = level
+	}
+	if filter.Max <= 0 {
+		filter.Max = 1000
+	}
+	messageID := strings.TrimSuffix(strings.TrimPrefix(filter.MessageID, "<"), ">")
+	text := strings.ToLower(filter.Text)
+
+	match := func(e mlog.Entry) bool {
+		if filter.Level != "" {
+			if level, ok := mlog.Levels[e.Level]; !ok || level < minLevel {
+				return false
+			}
+		}
+		if filter.Pkg != "" && !slices.Contains(e.Pkgs, filter.Pkg) {
+			return false
+		}
+		fieldMatch := func(key string, fn func(v string) bool) bool {
+			for _, f := range e.Fields {
+				if (f.Key == key || strings.HasSuffix(f.Key, "."+key)) && fn(f.Value) {
+					return true
+				}
+			}
+			return false
+		}
+		if filter.Account != "" && !fieldMatch("account", func(v string) bool { return v == filter.Account }) {
+			return false
+		}
+		if messageID != "" && !fieldMatch("messageid", func(v string) bool {
+			return strings.TrimSuffix(strings.TrimPrefix(v, "<"), ">") == messageID
+		}) {
+			return false
+		}
+		if filter.Cid != "" && !fieldMatch("cid", func(v string) bool { return strings.EqualFold(v, filter.Cid) }) {
+			return false
+		}
+		if text != "" && !strings.Contains(strings.ToLower(e.Message), text) {
+			found := false
+			for _, f := range e.Fields {
+				if strings.Contains(strings.ToLower(f.Value), text) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	}
+
+	entries := mlog.Recent()
+	l := []LogEntry{}
+	for i := len(entries) - 1; i >= 0 && len(l) < filter.Max; i-- {
+		e := entries[i]
+		if !match(e) {
+			continue
+		}
+		var pkg string
+		if len(e.Pkgs) > 0 {
+			pkg = e.Pkgs[len(e.Pkgs)-1]
+		}
+		fields := make([]LogField, len(e.Fields))
+		for j, f := range e.Fields {
+			fields[j] = LogField{f.Key, f.Value}
+		}
+		l = append(l, LogEntry{e.Time, e.Level, pkg, e.Message, fields})
+	}
+	return l
+}
+
 // CheckUpdatesEnabled returns whether checking for updates is enabled.
 func (Admin) CheckUpdatesEnabled(ctx context.Context) bool {
 	return mox.Conf.Static.CheckUpdates
@@ -2258,6 +3103,10 @@ func (Admin) WebserverConfigSave(ctx context.Context, oldConf, newConf Webserver
 
 // Transports returns the configured transports, for sending email.
 func (Admin) Transports(ctx context.Context) map[string]config.Transport {
+	// Transports are server-wide, and can contain credentials.
+	if _, ok := domainAdminDomains(ctx); ok {
+		return map[string]config.Transport{}
+	}
 	return mox.Conf.Static.Transports
 }
 
@@ -2437,6 +3286,25 @@ func (Admin) DomainRoutesSave(ctx context.Context, domainName string, routes []c
 	xcheckf(ctx, err, "saving domain routes")
 }
 
+// AccountFooterSave saves the footer added to messages submitted by an account.
+// A nil footer removes it.
+func (Admin) AccountFooterSave(ctx context.Context, accountName string, footer *config.Footer) {
+	err := mox.AccountSave(ctx, accountName, func(acc *config.Account) {
+		acc.Footer = footer
+	})
+	xcheckf(ctx, err, "saving account footer")
+}
+
+// DomainFooterSave saves the footer added to messages submitted with a message
+// From address in a domain. A nil footer removes it.
+func (Admin) DomainFooterSave(ctx context.Context, domainName string, footer *config.Footer) {
+	err := mox.DomainSave(ctx, domainName, func(domain *config.Domain) error {
+		domain.Footer = footer
+		return nil
+	})
+	xcheckf(ctx, err, "saving domain footer")
+}
+
 // RoutesSave saves global routes.
 func (Admin) RoutesSave(ctx context.Context, routes []config.Route) {
 	err := mox.ConfigSave(ctx, func(config *config.Dynamic) {
@@ -2445,6 +3313,23 @@ func (Admin) RoutesSave(ctx context.Context, routes []config.Route) {
 	xcheckf(ctx, err, "saving global routes")
 }
 
+// AddressRewritesSave saves the global address rewrite rules.
+func (Admin) AddressRewritesSave(ctx context.Context, rules []config.AddressRewrite) {
+	err := mox.ConfigSave(ctx, func(config *config.Dynamic) {
+		config.AddressRewrites = rules
+	})
+	xcheckf(ctx, err, "saving global address rewrites")
+}
+
+// DomainAddressRewritesSave saves the address rewrite rules for a domain.
+func (Admin) DomainAddressRewritesSave(ctx context.Context, domainName string, rules []config.AddressRewrite) {
+	err := mox.DomainSave(ctx, domainName, func(domain *config.Domain) error {
+		domain.AddressRewrites = rules
+		return nil
+	})
+	xcheckf(ctx, err, "saving domain address rewrites")
+}
+
 // DomainDescriptionSave saves the description for a domain.
 func (Admin) DomainDescriptionSave(ctx context.Context, domainName, descr string) {
 	err := mox.DomainSave(ctx, domainName, func(domain *config.Domain) error {
@@ -2454,6 +3339,15 @@ func (Admin) DomainDescriptionSave(ctx context.Context, domainName, descr string
 	xcheckf(ctx, err, "saving domain description")
 }
 
+// DomainAdminsSave saves the login addresses of domain admins for a domain.
+func (Admin) DomainAdminsSave(ctx context.Context, domainName string, admins []string) {
+	err := mox.DomainSave(ctx, domainName, func(domain *config.Domain) error {
+		domain.Admins = admins
+		return nil
+	})
+	xcheckf(ctx, err, "saving domain admins")
+}
+
 // DomainClientSettingsDomainSave saves the client settings domain for a domain.
 func (Admin) DomainClientSettingsDomainSave(ctx context.Context, domainName, clientSettingsDomain string) {
 	err := mox.DomainSave(ctx, domainName, func(domain *config.Domain) error {
@@ -2463,6 +3357,27 @@ func (Admin) DomainClientSettingsDomainSave(ctx context.Context, domainName, cli
 	xcheckf(ctx, err, "saving client settings domain")
 }
 
+// DomainSenderListsSave saves the allow/deny sender lists for incoming messages
+// to a domain. Senders are email addresses or domains as "@domain".
+func (Admin) DomainSenderListsSave(ctx context.Context, domainName string, allow, reject, junk []string) {
+	err := mox.DomainSave(ctx, domainName, func(domain *config.Domain) error {
+		domain.SenderAllow = allow
+		domain.SenderReject = reject
+		domain.SenderJunk = junk
+		return nil
+	})
+	xcheckf(ctx, err, "saving sender lists for domain")
+}
+
+// DomainSpamtrapsSave saves the localparts of the spamtrap addresses of a domain.
+func (Admin) DomainSpamtrapsSave(ctx context.Context, domainName string, localparts []string) {
+	err := mox.DomainSave(ctx, domainName, func(domain *config.Domain) error {
+		domain.Spamtraps = localparts
+		return nil
+	})
+	xcheckf(ctx, err, "saving spamtraps for domain")
+}
+
 // DomainLocalpartConfigSave saves the localpart catchall and case-sensitive
 // settings for a domain.
 func (Admin) DomainLocalpartConfigSave(ctx context.Context, domainName, localpartCatchallSeparator string, localpartCaseSensitive bool) {
@@ -2515,22 +3430,48 @@ func (Admin) DomainTLSRPTAddressSave(ctx context.Context, domainName, localpart,
 }
 
 // DomainMTASTSSave saves the MTASTS policy for a domain. If policyID is empty,
-// no MTASTS policy is served.
-func (Admin) DomainMTASTSSave(ctx context.Context, domainName, policyID string, mode mtasts.Mode, maxAge time.Duration, mx []string) {
+// no MTASTS policy is served. If the policy changes but policyID is the same as
+// the currently configured policy ID, a new policy ID is generated, so remote
+// mail servers fetch the new policy. The policy ID in effect is returned.
+//
+// For a policy in mode testing, a non-zero enforceAfter causes the policy to be
+// changed to mode enforce after that period without TLS failures reported.
+func (Admin) DomainMTASTSSave(ctx context.Context, domainName, policyID string, mode mtasts.Mode, maxAge time.Duration, mx []string, enforceAfter time.Duration) (rpolicyID string) {
+	if enforceAfter < 0 {
+		xcheckuserf(ctx, errors.New("must be >= 0"), "checking enforce after")
+	}
 	err := mox.DomainSave(ctx, domainName, func(d *config.Domain) error {
 		if policyID == "" {
 			d.MTASTS = nil
-		} else {
-			d.MTASTS = &config.MTASTS{
-				PolicyID: policyID,
-				Mode:     mode,
-				MaxAge:   maxAge,
-				MX:       mx,
+			return nil
+		}
+		if o := d.MTASTS; o != nil && o.PolicyID == policyID && (o.Mode != mode || o.MaxAge != maxAge || !slices.Equal(o.MX, mx) || o.EnforceAfter != enforceAfter) {
+			policyID = mox.MTASTSPolicyID(time.Now())
+			if policyID == o.PolicyID {
+				policyID += "a"
 			}
 		}
+		d.MTASTS = &config.MTASTS{
+			PolicyID:     policyID,
+			Mode:         mode,
+			MaxAge:       maxAge,
+			MX:           mx,
+			EnforceAfter: enforceAfter,
+		}
 		return nil
 	})
 	xcheckf(ctx, err, "saving mtasts policy for domain")
+	return policyID
+}
+
+// DomainMTASTSPromotion returns the status of automatic promotion of the MTA-STS
+// policy of a domain from mode testing to mode enforce.
+func (Admin) DomainMTASTSPromotion(ctx context.Context, domainName string) mtastspromote.Status {
+	d, err := dns.ParseDomain(domainName)
+	xcheckuserf(ctx, err, "parsing domain")
+	st, err := mtastspromote.Evaluate(ctx, d, time.Now())
+	xcheckf(ctx, err, "evaluating mtasts policy promotion")
+	return st
 }
 
 // DomainDKIMAdd adds a DKIM selector for a domain, generating a new private
@@ -2617,12 +3558,14 @@ func (Admin) AliasAdd(ctx context.Context, aliaslp string, domainName string, al
 	xcheckf(ctx, err, "adding alias")
 }
 
-func (Admin) AliasUpdate(ctx context.Context, aliaslp string, domainName string, postPublic, listMembers, allowMsgFrom bool) {
+func (Admin) AliasUpdate(ctx context.Context, aliaslp string, domainName string, postPublic, listMembers, allowMsgFrom bool, ownerAddress string, moderated bool) {
 	addr := xparseAddress(ctx, aliaslp, domainName)
 	alias := config.Alias{
 		PostPublic:   postPublic,
 		ListMembers:  listMembers,
 		AllowMsgFrom: allowMsgFrom,
+		OwnerAddress: ownerAddress,
+		Moderated:    moderated,
 	}
 	err := mox.AliasUpdate(ctx, addr, alias)
 	xcheckf(ctx, err, "saving alias")
@@ -226,6 +226,68 @@ const [dom, style, attr, prop] = (function () {
 	const prop = (x) => { return { _props: x }; };
 	return [dom, style, attr, prop];
 })();
+// Translations of the user interface. Text is looked up by its English version
+// with _(), which replaces placeholders "{0}", "{1}", etc. with parameters. The
+// language pack is fetched with i18nLoad, before building the user interface.
+let i18nLang = 'en';
+let i18nLocale; // For formatting dates/times, undefined for the browser default.
+let i18nLanguages = [{ Code: 'en', Name: 'English' }];
+let i18nTranslations = {};
+const _ = (s, ...args) => {
+	s = i18nTranslations[s] || s;
+	args.forEach((a, i) => {
+		s = s.split('{' + i + '}').join('' + a);
+	});
+	return s;
+};
+// i18nPreference returns the language explicitly chosen by the user, or an empty
+// string if the languages configured in the browser are used.
+const i18nPreference = () => {
+	try {
+		return window.localStorage.getItem('language') || '';
+	}
+	catch (err) {
+		return '';
+	}
+};
+// i18nPreferenceSet stores the language chosen by the user, an empty string
+// switches back to the browser languages. The page must be reloaded for a new
+// language to be used.
+const i18nPreferenceSet = (lang) => {
+	try {
+		if (lang) {
+			window.localStorage.setItem('language', lang);
+		}
+		else {
+			window.localStorage.removeItem('language');
+		}
+	}
+	catch (err) {
+		console.log('storing language preference', err);
+	}
+};
+// i18nLoad fetches the language pack for the preferred language. On failure,
+// English is used.
+const i18nLoad = async () => {
+	const browserLangs = navigator.languages || [navigator.language];
+	const lang = [i18nPreference(), ...browserLangs].filter(s => s).join(',');
+	try {
+		const resp = await fetch('i18n.json?lang=' + encodeURIComponent(lang));
+		if (!resp.ok) {
+			throw new Error('http status ' + resp.status);
+		}
+		const p = await resp.json();
+		i18nLang = p.Language;
+		i18nLanguages = p.Languages;
+		i18nTranslations = p.Translations || {};
+	}
+	catch (err) {
+		console.log('loading language pack, continuing with english', err);
+	}
+	// Format dates with the regional variant from the browser, e.g. "nl-BE".
+	i18nLocale = browserLangs.find(s => s.split('-')[0].toLowerCase() === i18nLang) || (i18nPreference() ? i18nLang : undefined);
+	document.documentElement.lang = i18nLang;
+};
 // NOTE: GENERATED by github.com/mjl-/sherpats, DO NOT MODIFY
 var api;
 (function (api) {
@@ -337,10 +399,34 @@ var api;
 		SPFResult["SPFTemperror"] = "temperror";
 		SPFResult["SPFPermerror"] = "permerror";
 	})(SPFResult = api.SPFResult || (api.SPFResult = {}));
-	api.structTypes = { "Account": true, "Address": true, "AddressAlias": true, "Alias": true, "AliasAddress": true, "AuthResults": true, "AutoconfCheckResult": true, "AutodiscoverCheckResult": true, "AutodiscoverSRV": true, "AutomaticJunkFlags": true, "Canonicalization": true, "CheckResult": true, "ClientConfigs": true, "ClientConfigsEntry": true, "ConfigDomain": true, "DANECheckResult": true, "DKIM": true, "DKIMAuthResult": true, "DKIMCheckResult": true, "DKIMRecord": true, "DMARC": true, "DMARCCheckResult": true, "DMARCRecord": true, "DMARCSummary": true, "DNSSECResult": true, "DateRange": true, "Destination": true, "Directive": true, "Domain": true, "DomainFeedback": true, "Dynamic": true, "Evaluation": true, "EvaluationStat": true, "Extension": true, "FailureDetails": true, "Filter": true, "HoldRule": true, "Hook": true, "HookFilter": true, "HookResult": true, "HookRetired": true, "HookRetiredFilter": true, "HookRetiredSort": true, "HookSort": true, "IPDomain": true, "IPRevCheckResult": true, "Identifiers": true, "IncomingWebhook": true, "JunkFilter": true, "MTASTS": true, "MTASTSCheckResult": true, "MTASTSRecord": true, "MX": true, "MXCheckResult": true, "Modifier": true, "Msg": true, "MsgResult": true, "MsgRetired": true, "OutgoingWebhook": true, "Pair": true, "Policy": true, "PolicyEvaluated": true, "PolicyOverrideReason": true, "PolicyPublished": true, "PolicyRecord": true, "Record": true, "Report": true, "ReportMetadata": true, "ReportRecord": true, "Result": true, "ResultPolicy": true, "RetiredFilter": true, "RetiredSort": true, "Reverse": true, "Route": true, "Row": true, "Ruleset": true, "SMTPAuth": true, "SPFAuthResult": true, "SPFCheckResult": true, "SPFRecord": true, "SRV": true, "SRVConfCheckResult": true, "STSMX": true, "Selector": true, "Sort": true, "SubjectPass": true, "Summary": true, "SuppressAddress": true, "TLSCheckResult": true, "TLSRPT": true, "TLSRPTCheckResult": true, "TLSRPTDateRange": true, "TLSRPTRecord": true, "TLSRPTSummary": true, "TLSRPTSuppressAddress": true, "TLSReportRecord": true, "TLSResult": true, "Transport": true, "TransportDirect": true, "TransportSMTP": true, "TransportSocks": true, "URI": true, "WebForward": true, "WebHandler": true, "WebRedirect": true, "WebStatic": true, "WebserverConfig": true };
-	api.stringsTypes = { "Align": true, "Alignment": true, "CSRFToken": true, "DKIMResult": true, "DMARCPolicy": true, "DMARCResult": true, "Disposition": true, "IP": true, "Localpart": true, "Mode": true, "PolicyOverride": true, "PolicyType": true, "RUA": true, "ResultType": true, "SPFDomainScope": true, "SPFResult": true };
+	// Role of an API token, determining which admin API methods it can call.
+	let Role;
+	(function (Role) {
+		Role["RoleReadonly"] = "readonly";
+		Role["RoleQueue"] = "queue";
+		Role["RoleDomains"] = "domains";
+		Role["RoleAdmin"] = "admin";
+	})(Role = api.Role || (api.Role = {}));
+	// EventKind is the kind of step in the handling of a message.
+	let EventKind;
+	(function (EventKind) {
+		EventKind["EventReceived"] = "received";
+		EventKind["EventRuleset"] = "ruleset";
+		EventKind["EventJunk"] = "junk";
+		EventKind["EventDelivered"] = "delivered";
+		EventKind["EventRejected"] = "rejected";
+		EventKind["EventQuarantined"] = "quarantined";
+		EventKind["EventDiscarded"] = "discarded";
+		EventKind["EventQueued"] = "queued";
+		EventKind["EventAttempt"] = "attempt";
+		EventKind["EventFailed"] = "failed";
+	})(EventKind = api.EventKind || (api.EventKind = {}));
+	api.structTypes = { "APIToken": true, "AbuseReport": true, "Account": true, "AccountDeletion": true, "Address": true, "AddressAlias": true, "AddressRewrite": true, "AdminScope": true, "Alias": true, "AliasAddress": true, "AuditEntry": true, "AuthResults": true, "AutoBanEntry": true, "AutoconfCheckResult": true, "AutodiscoverCheckResult": true, "AutodiscoverSRV": true, "AutomaticJunkFlags": true, "Canonicalization": true, "CertificateInfo": true, "CheckResult": true, "ClientConfigs": true, "ClientConfigsEntry": true, "ConfigDomain": true, "DANECheckResult": true, "DKIM": true, "DKIMAuthResult": true, "DKIMCheckResult": true, "DKIMRecord": true, "DMARC": true, "DMARCCheckResult": true, "DMARCRecord": true, "DMARCSummary": true, "DNSSECResult": true, "DateRange": true, "Destination": true, "Directive": true, "Domain": true, "DomainAuth": true, "DomainFeedback": true, "DomainSetup": true, "DomainSetupCheck": true, "DomainSetupRecord": true, "DomainSetupStatus": true, "Dynamic": true, "Evaluation": true, "EvaluationStat": true, "Extension": true, "FailureDetails": true, "Filter": true, "Footer": true, "HoldRule": true, "Hook": true, "HookFilter": true, "HookResult": true, "HookRetired": true, "HookRetiredFilter": true, "HookRetiredSort": true, "HookSort": true, "IPDomain": true, "IPRevCheckResult": true, "Identifiers": true, "IncomingWebhook": true, "JunkFilter": true, "LDAPAuth": true, "LineEndingSource": true, "LogEntry": true, "LogField": true, "LogFilter": true, "MTASTS": true, "MTASTSCheckResult": true, "MTASTSRecord": true, "MX": true, "MXCheckResult": true, "MessageEvent": true, "Modifier": true, "Msg": true, "MsgResult": true, "MsgRetired": true, "OutgoingWebhook": true, "PAMAuth": true, "Pair": true, "Passkey": true, "PasskeyAssertion": true, "PasskeyAttestation": true, "PasskeyCreationOptions": true, "PasskeyRequestOptions": true, "PasswordPolicy": true, "Policy": true, "PolicyEvaluated": true, "PolicyOverrideReason": true, "PolicyPublished": true, "PolicyRecord": true, "ProtocolSession": true, "Quarantined": true, "Record": true, "Report": true, "ReportMetadata": true, "ReportRecord": true, "Result": true, "ResultPolicy": true, "RetiredFilter": true, "RetiredSort": true, "Reverse": true, "Route": true, "Row": true, "Ruleset": true, "SMTPAuth": true, "SPFAuthResult": true, "SPFCheckResult": true, "SPFRecord": true, "SRV": true, "SRVConfCheckResult": true, "STSMX": true, "Selector": true, "Sort": true, "SpamtrapHit": true, "StaticReload": true, "Status": true, "SubjectPass": true, "SubmissionIncident": true, "Summary": true, "SuppressAddress": true, "TLSCheckResult": true, "TLSRPT": true, "TLSRPTCheckResult": true, "TLSRPTDateRange": true, "TLSRPTRecord": true, "TLSRPTSummary": true, "TLSRPTSuppressAddress": true, "TLSReportRecord": true, "TLSResult": true, "Transport": true, "TransportDirect": true, "TransportSMTP": true, "TransportSocks": true, "URI": true, "Usage": true, "WebAccess": true, "WebBasicAuth": true, "WebForward": true, "WebHandler": true, "WebHeaderRewrite": true, "WebOIDCAuth": true, "WebRateLimit": true, "WebRedirect": true, "WebRule": true, "WebStatic": true, "WebserverConfig": true };
+	api.stringsTypes = { "Align": true, "Alignment": true, "CSRFToken": true, "DKIMResult": true, "DMARCPolicy": true, "DMARCResult": true, "Disposition": true, "EventKind": true, "IP": true, "Localpart": true, "Mode": true, "PolicyOverride": true, "PolicyType": true, "RUA": true, "ResultType": true, "Role": true, "SPFDomainScope": true, "SPFResult": true };
 	api.intsTypes = {};
 	api.types = {
+		"PasskeyRequestOptions": { "Name": "PasskeyRequestOptions", "Docs": "", "Fields": [{ "Name": "Challenge", "Docs": "", "Typewords": ["string"] }, { "Name": "RPID", "Docs": "", "Typewords": ["string"] }, { "Name": "Timeout", "Docs": "", "Typewords": ["int32"] }] },
+		"PasskeyAssertion": { "Name": "PasskeyAssertion", "Docs": "", "Fields": [{ "Name": "CredentialID", "Docs": "", "Typewords": ["string"] }, { "Name": "ClientDataJSON", "Docs": "", "Typewords": ["string"] }, { "Name": "AuthenticatorData", "Docs": "", "Typewords": ["string"] }, { "Name": "Signature", "Docs": "", "Typewords": ["string"] }, { "Name": "UserHandle", "Docs": "", "Typewords": ["string"] }] },
 		"CheckResult": { "Name": "CheckResult", "Docs": "", "Fields": [{ "Name": "Domain", "Docs": "", "Typewords": ["string"] }, { "Name": "DNSSEC", "Docs": "", "Typewords": ["DNSSECResult"] }, { "Name": "IPRev", "Docs": "", "Typewords": ["IPRevCheckResult"] }, { "Name": "MX", "Docs": "", "Typewords": ["MXCheckResult"] }, { "Name": "TLS", "Docs": "", "Typewords": ["TLSCheckResult"] }, { "Name": "DANE", "Docs": "", "Typewords": ["DANECheckResult"] }, { "Name": "SPF", "Docs": "", "Typewords": ["SPFCheckResult"] }, { "Name": "DKIM", "Docs": "", "Typewords": ["DKIMCheckResult"] }, { "Name": "DMARC", "Docs": "", "Typewords": ["DMARCCheckResult"] }, { "Name": "HostTLSRPT", "Docs": "", "Typewords": ["TLSRPTCheckResult"] }, { "Name": "DomainTLSRPT", "Docs": "", "Typewords": ["TLSRPTCheckResult"] }, { "Name": "MTASTS", "Docs": "", "Typewords": ["MTASTSCheckResult"] }, { "Name": "SRVConf", "Docs": "", "Typewords": ["SRVConfCheckResult"] }, { "Name": "Autoconf", "Docs": "", "Typewords": ["AutoconfCheckResult"] }, { "Name": "Autodiscover", "Docs": "", "Typewords": ["AutodiscoverCheckResult"] }] },
 		"DNSSECResult": { "Name": "DNSSECResult", "Docs": "", "Fields": [{ "Name": "Errors", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Warnings", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Instructions", "Docs": "", "Typewords": ["[]", "string"] }] },
 		"IPRevCheckResult": { "Name": "IPRevCheckResult", "Docs": "", "Fields": [{ "Name": "Hostname", "Docs": "", "Typewords": ["Domain"] }, { "Name": "IPNames", "Docs": "", "Typewords": ["{}", "[]", "string"] }, { "Name": "Errors", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Warnings", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Instructions", "Docs": "", "Typewords": ["[]", "string"] }] },
@@ -367,30 +453,38 @@ var api;
 		"Pair": { "Name": "Pair", "Docs": "", "Fields": [{ "Name": "Key", "Docs": "", "Typewords": ["string"] }, { "Name": "Value", "Docs": "", "Typewords": ["string"] }] },
 		"Policy": { "Name": "Policy", "Docs": "", "Fields": [{ "Name": "Version", "Docs": "", "Typewords": ["string"] }, { "Name": "Mode", "Docs": "", "Typewords": ["Mode"] }, { "Name": "MX", "Docs": "", "Typewords": ["[]", "STSMX"] }, { "Name": "MaxAgeSeconds", "Docs": "", "Typewords": ["int32"] }, { "Name": "Extensions", "Docs": "", "Typewords": ["[]", "Pair"] }] },
 		"STSMX": { "Name": "STSMX", "Docs": "", "Fields": [{ "Name": "Wildcard", "Docs": "", "Typewords": ["bool"] }, { "Name": "Domain", "Docs": "", "Typewords": ["Domain"] }] },
+		"CertificateInfo": { "Name": "CertificateInfo", "Docs": "", "Fields": [{ "Name": "Host", "Docs": "", "Typewords": ["string"] }, { "Name": "Provider", "Docs": "", "Typewords": ["string"] }, { "Name": "IssuerOrganization", "Docs": "", "Typewords": ["string"] }, { "Name": "IssuerCommonName", "Docs": "", "Typewords": ["string"] }, { "Name": "SerialNumber", "Docs": "", "Typewords": ["string"] }, { "Name": "NotBefore", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "NotAfter", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "DNS01", "Docs": "", "Typewords": ["bool"] }, { "Name": "Active", "Docs": "", "Typewords": ["bool"] }, { "Name": "RenewalStart", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "RenewalEnd", "Docs": "", "Typewords": ["timestamp"] }] },
 		"SRVConfCheckResult": { "Name": "SRVConfCheckResult", "Docs": "", "Fields": [{ "Name": "SRVs", "Docs": "", "Typewords": ["{}", "[]", "SRV"] }, { "Name": "Errors", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Warnings", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Instructions", "Docs": "", "Typewords": ["[]", "string"] }] },
 		"SRV": { "Name": "SRV", "Docs": "", "Fields": [{ "Name": "Target", "Docs": "", "Typewords": ["string"] }, { "Name": "Port", "Docs": "", "Typewords": ["uint16"] }, { "Name": "Priority", "Docs": "", "Typewords": ["uint16"] }, { "Name": "Weight", "Docs": "", "Typewords": ["uint16"] }] },
 		"AutoconfCheckResult": { "Name": "AutoconfCheckResult", "Docs": "", "Fields": [{ "Name": "ClientSettingsDomainIPs", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "IPs", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Errors", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Warnings", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Instructions", "Docs": "", "Typewords": ["[]", "string"] }] },
 		"AutodiscoverCheckResult": { "Name": "AutodiscoverCheckResult", "Docs": "", "Fields": [{ "Name": "Records", "Docs": "", "Typewords": ["[]", "AutodiscoverSRV"] }, { "Name": "Errors", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Warnings", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Instructions", "Docs": "", "Typewords": ["[]", "string"] }] },
 		"AutodiscoverSRV": { "Name": "AutodiscoverSRV", "Docs": "", "Fields": [{ "Name": "Target", "Docs": "", "Typewords": ["string"] }, { "Name": "Port", "Docs": "", "Typewords": ["uint16"] }, { "Name": "Priority", "Docs": "", "Typewords": ["uint16"] }, { "Name": "Weight", "Docs": "", "Typewords": ["uint16"] }, { "Name": "IPs", "Docs": "", "Typewords": ["[]", "string"] }] },
-		"ConfigDomain": { "Name": "ConfigDomain", "Docs": "", "Fields": [{ "Name": "Description", "Docs": "", "Typewords": ["string"] }, { "Name": "ClientSettingsDomain", "Docs": "", "Typewords": ["string"] }, { "Name": "LocalpartCatchallSeparator", "Docs": "", "Typewords": ["string"] }, { "Name": "LocalpartCaseSensitive", "Docs": "", "Typewords": ["bool"] }, { "Name": "DKIM", "Docs": "", "Typewords": ["DKIM"] }, { "Name": "DMARC", "Docs": "", "Typewords": ["nullable", "DMARC"] }, { "Name": "MTASTS", "Docs": "", "Typewords": ["nullable", "MTASTS"] }, { "Name": "TLSRPT", "Docs": "", "Typewords": ["nullable", "TLSRPT"] }, { "Name": "Routes", "Docs": "", "Typewords": ["[]", "Route"] }, { "Name": "Aliases", "Docs": "", "Typewords": ["{}", "Alias"] }, { "Name": "Domain", "Docs": "", "Typewords": ["Domain"] }] },
+		"ConfigDomain": { "Name": "ConfigDomain", "Docs": "", "Fields": [{ "Name": "Description", "Docs": "", "Typewords": ["string"] }, { "Name": "ClientSettingsDomain", "Docs": "", "Typewords": ["string"] }, { "Name": "LocalpartCatchallSeparator", "Docs": "", "Typewords": ["string"] }, { "Name": "LocalpartCaseSensitive", "Docs": "", "Typewords": ["bool"] }, { "Name": "DKIM", "Docs": "", "Typewords": ["DKIM"] }, { "Name": "DMARC", "Docs": "", "Typewords": ["nullable", "DMARC"] }, { "Name": "MTASTS", "Docs": "", "Typewords": ["nullable", "MTASTS"] }, { "Name": "TLSRPT", "Docs": "", "Typewords": ["nullable", "TLSRPT"] }, { "Name": "Routes", "Docs": "", "Typewords": ["[]", "Route"] }, { "Name": "Aliases", "Docs": "", "Typewords": ["{}", "Alias"] }, { "Name": "RequireTOTP", "Docs": "", "Typewords": ["bool"] }, { "Name": "Admins", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "SenderAllow", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "SenderReject", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "SenderJunk", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Spamtraps", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Auth", "Docs": "", "Typewords": ["nullable", "DomainAuth"] }, { "Name": "Footer", "Docs": "", "Typewords": ["nullable", "Footer"] }, { "Name": "AddressRewrites", "Docs": "", "Typewords": ["[]", "AddressRewrite"] }, { "Name": "Domain", "Docs": "", "Typewords": ["Domain"] }] },
 		"DKIM": { "Name": "DKIM", "Docs": "", "Fields": [{ "Name": "Selectors", "Docs": "", "Typewords": ["{}", "Selector"] }, { "Name": "Sign", "Docs": "", "Typewords": ["[]", "string"] }] },
 		"Selector": { "Name": "Selector", "Docs": "", "Fields": [{ "Name": "Hash", "Docs": "", "Typewords": ["string"] }, { "Name": "HashEffective", "Docs": "", "Typewords": ["string"] }, { "Name": "Canonicalization", "Docs": "", "Typewords": ["Canonicalization"] }, { "Name": "Headers", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "HeadersEffective", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "DontSealHeaders", "Docs": "", "Typewords": ["bool"] }, { "Name": "Expiration", "Docs": "", "Typewords": ["string"] }, { "Name": "PrivateKeyFile", "Docs": "", "Typewords": ["string"] }, { "Name": "Algorithm", "Docs": "", "Typewords": ["string"] }] },
 		"Canonicalization": { "Name": "Canonicalization", "Docs": "", "Fields": [{ "Name": "HeaderRelaxed", "Docs": "", "Typewords": ["bool"] }, { "Name": "BodyRelaxed", "Docs": "", "Typewords": ["bool"] }] },
 		"DMARC": { "Name": "DMARC", "Docs": "", "Fields": [{ "Name": "Localpart", "Docs": "", "Typewords": ["string"] }, { "Name": "Domain", "Docs": "", "Typewords": ["string"] }, { "Name": "Account", "Docs": "", "Typewords": ["string"] }, { "Name": "Mailbox", "Docs": "", "Typewords": ["string"] }, { "Name": "ParsedLocalpart", "Docs": "", "Typewords": ["Localpart"] }, { "Name": "DNSDomain", "Docs": "", "Typewords": ["Domain"] }] },
-		"MTASTS": { "Name": "MTASTS", "Docs": "", "Fields": [{ "Name": "PolicyID", "Docs": "", "Typewords": ["string"] }, { "Name": "Mode", "Docs": "", "Typewords": ["Mode"] }, { "Name": "MaxAge", "Docs": "", "Typewords": ["int64"] }, { "Name": "MX", "Docs": "", "Typewords": ["[]", "string"] }] },
+		"MTASTS": { "Name": "MTASTS", "Docs": "", "Fields": [{ "Name": "PolicyID", "Docs": "", "Typewords": ["string"] }, { "Name": "Mode", "Docs": "", "Typewords": ["Mode"] }, { "Name": "MaxAge", "Docs": "", "Typewords": ["int64"] }, { "Name": "MX", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "EnforceAfter", "Docs": "", "Typewords": ["int64"] }] },
+		"Status": { "Name": "Status", "Docs": "", "Fields": [{ "Name": "Domain", "Docs": "", "Typewords": ["string"] }, { "Name": "PolicyID", "Docs": "", "Typewords": ["string"] }, { "Name": "Mode", "Docs": "", "Typewords": ["Mode"] }, { "Name": "EnforceAfter", "Docs": "", "Typewords": ["int64"] }, { "Name": "TestingStart", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "LastFailure", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "CleanReports", "Docs": "", "Typewords": ["int32"] }, { "Name": "PromoteAt", "Docs": "", "Typewords": ["timestamp"] }] },
 		"TLSRPT": { "Name": "TLSRPT", "Docs": "", "Fields": [{ "Name": "Localpart", "Docs": "", "Typewords": ["string"] }, { "Name": "Domain", "Docs": "", "Typewords": ["string"] }, { "Name": "Account", "Docs": "", "Typewords": ["string"] }, { "Name": "Mailbox", "Docs": "", "Typewords": ["string"] }, { "Name": "ParsedLocalpart", "Docs": "", "Typewords": ["Localpart"] }, { "Name": "DNSDomain", "Docs": "", "Typewords": ["Domain"] }] },
 		"Route": { "Name": "Route", "Docs": "", "Fields": [{ "Name": "FromDomain", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "ToDomain", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "MinimumAttempts", "Docs": "", "Typewords": ["int32"] }, { "Name": "Transport", "Docs": "", "Typewords": ["string"] }, { "Name": "FromDomainASCII", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "ToDomainASCII", "Docs": "", "Typewords": ["[]", "string"] }] },
-		"Alias": { "Name": "Alias", "Docs": "", "Fields": [{ "Name": "Addresses", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "PostPublic", "Docs": "", "Typewords": ["bool"] }, { "Name": "ListMembers", "Docs": "", "Typewords": ["bool"] }, { "Name": "AllowMsgFrom", "Docs": "", "Typewords": ["bool"] }, { "Name": "LocalpartStr", "Docs": "", "Typewords": ["string"] }, { "Name": "Domain", "Docs": "", "Typewords": ["Domain"] }, { "Name": "ParsedAddresses", "Docs": "", "Typewords": ["[]", "AliasAddress"] }] },
+		"Alias": { "Name": "Alias", "Docs": "", "Fields": [{ "Name": "Addresses", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "PostPublic", "Docs": "", "Typewords": ["bool"] }, { "Name": "ListMembers", "Docs": "", "Typewords": ["bool"] }, { "Name": "AllowMsgFrom", "Docs": "", "Typewords": ["bool"] }, { "Name": "Owner", "Docs": "", "Typewords": ["string"] }, { "Name": "OwnerAddress", "Docs": "", "Typewords": ["string"] }, { "Name": "Moderated", "Docs": "", "Typewords": ["bool"] }, { "Name": "LocalpartStr", "Docs": "", "Typewords": ["string"] }, { "Name": "Domain", "Docs": "", "Typewords": ["Domain"] }, { "Name": "ParsedAddresses", "Docs": "", "Typewords": ["[]", "AliasAddress"] }, { "Name": "ExternalAddresses", "Docs": "", "Typewords": ["[]", "Address"] }, { "Name": "ParsedOwner", "Docs": "", "Typewords": ["AliasAddress"] }] },
 		"AliasAddress": { "Name": "AliasAddress", "Docs": "", "Fields": [{ "Name": "Address", "Docs": "", "Typewords": ["Address"] }, { "Name": "AccountName", "Docs": "", "Typewords": ["string"] }, { "Name": "Destination", "Docs": "", "Typewords": ["Destination"] }] },
 		"Address": { "Name": "Address", "Docs": "", "Fields": [{ "Name": "Localpart", "Docs": "", "Typewords": ["Localpart"] }, { "Name": "Domain", "Docs": "", "Typewords": ["Domain"] }] },
 		"Destination": { "Name": "Destination", "Docs": "", "Fields": [{ "Name": "Mailbox", "Docs": "", "Typewords": ["string"] }, { "Name": "Rulesets", "Docs": "", "Typewords": ["[]", "Ruleset"] }, { "Name": "FullName", "Docs": "", "Typewords": ["string"] }] },
 		"Ruleset": { "Name": "Ruleset", "Docs": "", "Fields": [{ "Name": "SMTPMailFromRegexp", "Docs": "", "Typewords": ["string"] }, { "Name": "MsgFromRegexp", "Docs": "", "Typewords": ["string"] }, { "Name": "VerifiedDomain", "Docs": "", "Typewords": ["string"] }, { "Name": "HeadersRegexp", "Docs": "", "Typewords": ["{}", "string"] }, { "Name": "IsForward", "Docs": "", "Typewords": ["bool"] }, { "Name": "ListAllowDomain", "Docs": "", "Typewords": ["string"] }, { "Name": "AcceptRejectsToMailbox", "Docs": "", "Typewords": ["string"] }, { "Name": "Mailbox", "Docs": "", "Typewords": ["string"] }, { "Name": "Comment", "Docs": "", "Typewords": ["string"] }, { "Name": "VerifiedDNSDomain", "Docs": "", "Typewords": ["Domain"] }, { "Name": "ListAllowDNSDomain", "Docs": "", "Typewords": ["Domain"] }] },
-		"Account": { "Name": "Account", "Docs": "", "Fields": [{ "Name": "OutgoingWebhook", "Docs": "", "Typewords": ["nullable", "OutgoingWebhook"] }, { "Name": "IncomingWebhook", "Docs": "", "Typewords": ["nullable", "IncomingWebhook"] }, { "Name": "FromIDLoginAddresses", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "KeepRetiredMessagePeriod", "Docs": "", "Typewords": ["int64"] }, { "Name": "KeepRetiredWebhookPeriod", "Docs": "", "Typewords": ["int64"] }, { "Name": "Domain", "Docs": "", "Typewords": ["string"] }, { "Name": "Description", "Docs": "", "Typewords": ["string"] }, { "Name": "FullName", "Docs": "", "Typewords": ["string"] }, { "Name": "Destinations", "Docs": "", "Typewords": ["{}", "Destination"] }, { "Name": "SubjectPass", "Docs": "", "Typewords": ["SubjectPass"] }, { "Name": "QuotaMessageSize", "Docs": "", "Typewords": ["int64"] }, { "Name": "RejectsMailbox", "Docs": "", "Typewords": ["string"] }, { "Name": "KeepRejects", "Docs": "", "Typewords": ["bool"] }, { "Name": "AutomaticJunkFlags", "Docs": "", "Typewords": ["AutomaticJunkFlags"] }, { "Name": "JunkFilter", "Docs": "", "Typewords": ["nullable", "JunkFilter"] }, { "Name": "MaxOutgoingMessagesPerDay", "Docs": "", "Typewords": ["int32"] }, { "Name": "MaxFirstTimeRecipientsPerDay", "Docs": "", "Typewords": ["int32"] }, { "Name": "NoFirstTimeSenderDelay", "Docs": "", "Typewords": ["bool"] }, { "Name": "Routes", "Docs": "", "Typewords": ["[]", "Route"] }, { "Name": "DNSDomain", "Docs": "", "Typewords": ["Domain"] }, { "Name": "Aliases", "Docs": "", "Typewords": ["[]", "AddressAlias"] }] },
+		"DomainAuth": { "Name": "DomainAuth", "Docs": "", "Fields": [{ "Name": "LDAP", "Docs": "", "Typewords": ["nullable", "LDAPAuth"] }, { "Name": "PAM", "Docs": "", "Typewords": ["nullable", "PAMAuth"] }, { "Name": "UsernameEmail", "Docs": "", "Typewords": ["bool"] }, { "Name": "AutoProvision", "Docs": "", "Typewords": ["bool"] }] },
+		"LDAPAuth": { "Name": "LDAPAuth", "Docs": "", "Fields": [{ "Name": "URL", "Docs": "", "Typewords": ["string"] }, { "Name": "StartTLS", "Docs": "", "Typewords": ["bool"] }, { "Name": "UserDNTemplate", "Docs": "", "Typewords": ["string"] }, { "Name": "BindDN", "Docs": "", "Typewords": ["string"] }, { "Name": "BindPassword", "Docs": "", "Typewords": ["string"] }, { "Name": "BaseDN", "Docs": "", "Typewords": ["string"] }, { "Name": "Filter", "Docs": "", "Typewords": ["string"] }] },
+		"PAMAuth": { "Name": "PAMAuth", "Docs": "", "Fields": [{ "Name": "Service", "Docs": "", "Typewords": ["string"] }] },
+		"Footer": { "Name": "Footer", "Docs": "", "Fields": [{ "Name": "Text", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "HTML", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "SkipReplies", "Docs": "", "Typewords": ["bool"] }] },
+		"PasswordPolicy": { "Name": "PasswordPolicy", "Docs": "", "Fields": [{ "Name": "MinLength", "Docs": "", "Typewords": ["int32"] }, { "Name": "MinEntropy", "Docs": "", "Typewords": ["int32"] }, { "Name": "DenyList", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "DenyListFile", "Docs": "", "Typewords": ["string"] }, { "Name": "BreachCheck", "Docs": "", "Typewords": ["bool"] }, { "Name": "BreachCheckURL", "Docs": "", "Typewords": ["string"] }] },
+		"AddressRewrite": { "Name": "AddressRewrite", "Docs": "", "Fields": [{ "Name": "AddressRegexp", "Docs": "", "Typewords": ["string"] }, { "Name": "Replace", "Docs": "", "Typewords": ["string"] }, { "Name": "Priority", "Docs": "", "Typewords": ["int32"] }, { "Name": "Sender", "Docs": "", "Typewords": ["bool"] }, { "Name": "Recipient", "Docs": "", "Typewords": ["bool"] }] },
+		"Account": { "Name": "Account", "Docs": "", "Fields": [{ "Name": "OutgoingWebhook", "Docs": "", "Typewords": ["nullable", "OutgoingWebhook"] }, { "Name": "IncomingWebhook", "Docs": "", "Typewords": ["nullable", "IncomingWebhook"] }, { "Name": "FromIDLoginAddresses", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "KeepRetiredMessagePeriod", "Docs": "", "Typewords": ["int64"] }, { "Name": "KeepRetiredWebhookPeriod", "Docs": "", "Typewords": ["int64"] }, { "Name": "Domain", "Docs": "", "Typewords": ["string"] }, { "Name": "Description", "Docs": "", "Typewords": ["string"] }, { "Name": "FullName", "Docs": "", "Typewords": ["string"] }, { "Name": "Destinations", "Docs": "", "Typewords": ["{}", "Destination"] }, { "Name": "SubjectPass", "Docs": "", "Typewords": ["SubjectPass"] }, { "Name": "QuotaMessageSize", "Docs": "", "Typewords": ["int64"] }, { "Name": "CompressMessages", "Docs": "", "Typewords": ["bool"] }, { "Name": "RejectsMailbox", "Docs": "", "Typewords": ["string"] }, { "Name": "KeepRejects", "Docs": "", "Typewords": ["bool"] }, { "Name": "AutomaticJunkFlags", "Docs": "", "Typewords": ["AutomaticJunkFlags"] }, { "Name": "JunkFilter", "Docs": "", "Typewords": ["nullable", "JunkFilter"] }, { "Name": "MaxOutgoingMessagesPerDay", "Docs": "", "Typewords": ["int32"] }, { "Name": "MaxFirstTimeRecipientsPerDay", "Docs": "", "Typewords": ["int32"] }, { "Name": "MaxAliases", "Docs": "", "Typewords": ["int32"] }, { "Name": "NoFirstTimeSenderDelay", "Docs": "", "Typewords": ["bool"] }, { "Name": "RequireTOTP", "Docs": "", "Typewords": ["bool"] }, { "Name": "LoginDisabled", "Docs": "", "Typewords": ["string"] }, { "Name": "Routes", "Docs": "", "Typewords": ["[]", "Route"] }, { "Name": "Footer", "Docs": "", "Typewords": ["nullable", "Footer"] }, { "Name": "PasswordPolicy", "Docs": "", "Typewords": ["nullable", "PasswordPolicy"] }, { "Name": "DNSDomain", "Docs": "", "Typewords": ["Domain"] }, { "Name": "Aliases", "Docs": "", "Typewords": ["[]", "AddressAlias"] }] },
 		"OutgoingWebhook": { "Name": "OutgoingWebhook", "Docs": "", "Fields": [{ "Name": "URL", "Docs": "", "Typewords": ["string"] }, { "Name": "Authorization", "Docs": "", "Typewords": ["string"] }, { "Name": "Events", "Docs": "", "Typewords": ["[]", "string"] }] },
 		"IncomingWebhook": { "Name": "IncomingWebhook", "Docs": "", "Fields": [{ "Name": "URL", "Docs": "", "Typewords": ["string"] }, { "Name": "Authorization", "Docs": "", "Typewords": ["string"] }] },
 		"SubjectPass": { "Name": "SubjectPass", "Docs": "", "Fields": [{ "Name": "Period", "Docs": "", "Typewords": ["int64"] }] },
 		"AutomaticJunkFlags": { "Name": "AutomaticJunkFlags", "Docs": "", "Fields": [{ "Name": "Enabled", "Docs": "", "Typewords": ["bool"] }, { "Name": "JunkMailboxRegexp", "Docs": "", "Typewords": ["string"] }, { "Name": "NeutralMailboxRegexp", "Docs": "", "Typewords": ["string"] }, { "Name": "NotJunkMailboxRegexp", "Docs": "", "Typewords": ["string"] }] },
-		"JunkFilter": { "Name": "JunkFilter", "Docs": "", "Fields": [{ "Name": "Threshold", "Docs": "", "Typewords": ["float64"] }, { "Name": "Onegrams", "Docs": "", "Typewords": ["bool"] }, { "Name": "Twograms", "Docs": "", "Typewords": ["bool"] }, { "Name": "Threegrams", "Docs": "", "Typewords": ["bool"] }, { "Name": "MaxPower", "Docs": "", "Typewords": ["float64"] }, { "Name": "TopWords", "Docs": "", "Typewords": ["int32"] }, { "Name": "IgnoreWords", "Docs": "", "Typewords": ["float64"] }, { "Name": "RareWords", "Docs": "", "Typewords": ["int32"] }] },
+		"JunkFilter": { "Name": "JunkFilter", "Docs": "", "Fields": [{ "Name": "Threshold", "Docs": "", "Typewords": ["float64"] }, { "Name": "SharedWeight", "Docs": "", "Typewords": ["float64"] }, { "Name": "ContributeShared", "Docs": "", "Typewords": ["bool"] }, { "Name": "DelayFlagTraining", "Docs": "", "Typewords": ["bool"] }, { "Name": "Onegrams", "Docs": "", "Typewords": ["bool"] }, { "Name": "Twograms", "Docs": "", "Typewords": ["bool"] }, { "Name": "Threegrams", "Docs": "", "Typewords": ["bool"] }, { "Name": "MaxPower", "Docs": "", "Typewords": ["float64"] }, { "Name": "TopWords", "Docs": "", "Typewords": ["int32"] }, { "Name": "IgnoreWords", "Docs": "", "Typewords": ["float64"] }, { "Name": "RareWords", "Docs": "", "Typewords": ["int32"] }] },
 		"AddressAlias": { "Name": "AddressAlias", "Docs": "", "Fields": [{ "Name": "SubscriptionAddress", "Docs": "", "Typewords": ["string"] }, { "Name": "Alias", "Docs": "", "Typewords": ["Alias"] }, { "Name": "MemberAddresses", "Docs": "", "Typewords": ["[]", "string"] }] },
 		"PolicyRecord": { "Name": "PolicyRecord", "Docs": "", "Fields": [{ "Name": "Domain", "Docs": "", "Typewords": ["string"] }, { "Name": "Inserted", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "ValidEnd", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "LastUpdate", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "LastUse", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Backoff", "Docs": "", "Typewords": ["bool"] }, { "Name": "RecordID", "Docs": "", "Typewords": ["string"] }, { "Name": "Version", "Docs": "", "Typewords": ["string"] }, { "Name": "Mode", "Docs": "", "Typewords": ["Mode"] }, { "Name": "MX", "Docs": "", "Typewords": ["[]", "STSMX"] }, { "Name": "MaxAgeSeconds", "Docs": "", "Typewords": ["int32"] }, { "Name": "Extensions", "Docs": "", "Typewords": ["[]", "Pair"] }, { "Name": "PolicyText", "Docs": "", "Typewords": ["string"] }] },
 		"TLSReportRecord": { "Name": "TLSReportRecord", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Domain", "Docs": "", "Typewords": ["string"] }, { "Name": "FromDomain", "Docs": "", "Typewords": ["string"] }, { "Name": "MailFrom", "Docs": "", "Typewords": ["string"] }, { "Name": "HostReport", "Docs": "", "Typewords": ["bool"] }, { "Name": "Report", "Docs": "", "Typewords": ["Report"] }] },
@@ -415,12 +509,31 @@ var api;
 		"SPFAuthResult": { "Name": "SPFAuthResult", "Docs": "", "Fields": [{ "Name": "Domain", "Docs": "", "Typewords": ["string"] }, { "Name": "Scope", "Docs": "", "Typewords": ["SPFDomainScope"] }, { "Name": "Result", "Docs": "", "Typewords": ["SPFResult"] }] },
 		"DMARCSummary": { "Name": "DMARCSummary", "Docs": "", "Fields": [{ "Name": "Domain", "Docs": "", "Typewords": ["string"] }, { "Name": "Total", "Docs": "", "Typewords": ["int32"] }, { "Name": "DispositionNone", "Docs": "", "Typewords": ["int32"] }, { "Name": "DispositionQuarantine", "Docs": "", "Typewords": ["int32"] }, { "Name": "DispositionReject", "Docs": "", "Typewords": ["int32"] }, { "Name": "DKIMFail", "Docs": "", "Typewords": ["int32"] }, { "Name": "SPFFail", "Docs": "", "Typewords": ["int32"] }, { "Name": "PolicyOverrides", "Docs": "", "Typewords": ["{}", "int32"] }] },
 		"Reverse": { "Name": "Reverse", "Docs": "", "Fields": [{ "Name": "Hostnames", "Docs": "", "Typewords": ["[]", "string"] }] },
+		"ProtocolSession": { "Name": "ProtocolSession", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Protocol", "Docs": "", "Typewords": ["string"] }, { "Name": "LoginAddress", "Docs": "", "Typewords": ["string"] }, { "Name": "RemoteIP", "Docs": "", "Typewords": ["string"] }, { "Name": "ClientID", "Docs": "", "Typewords": ["string"] }, { "Name": "Started", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "LastActivity", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Ended", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Active", "Docs": "", "Typewords": ["bool"] }, { "Name": "Closed", "Docs": "", "Typewords": ["bool"] }] },
+		"Passkey": { "Name": "Passkey", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Label", "Docs": "", "Typewords": ["string"] }, { "Name": "LoginAddress", "Docs": "", "Typewords": ["string"] }, { "Name": "Created", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "LastUsed", "Docs": "", "Typewords": ["timestamp"] }] },
+		"AccountDeletion": { "Name": "AccountDeletion", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Account", "Docs": "", "Typewords": ["string"] }, { "Name": "Requested", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "PurgeAfter", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "RequestedBy", "Docs": "", "Typewords": ["string"] }, { "Name": "Addresses", "Docs": "", "Typewords": ["[]", "string"] }] },
+		"SubmissionIncident": { "Name": "SubmissionIncident", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Time", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Account", "Docs": "", "Typewords": ["string"] }, { "Name": "Source", "Docs": "", "Typewords": ["string"] }, { "Name": "RemoteIP", "Docs": "", "Typewords": ["string"] }, { "Name": "Anomalies", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Action", "Docs": "", "Typewords": ["string"] }, { "Name": "Until", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Cleared", "Docs": "", "Typewords": ["bool"] }] },
+		"SpamtrapHit": { "Name": "SpamtrapHit", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Time", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Trap", "Docs": "", "Typewords": ["string"] }, { "Name": "RemoteIP", "Docs": "", "Typewords": ["string"] }, { "Name": "RemoteNetwork", "Docs": "", "Typewords": ["string"] }, { "Name": "EHLO", "Docs": "", "Typewords": ["string"] }, { "Name": "MailFrom", "Docs": "", "Typewords": ["string"] }, { "Name": "Domain", "Docs": "", "Typewords": ["string"] }, { "Name": "MsgFrom", "Docs": "", "Typewords": ["string"] }, { "Name": "Subject", "Docs": "", "Typewords": ["string"] }, { "Name": "Size", "Docs": "", "Typewords": ["int64"] }] },
+		"AbuseReport": { "Name": "AbuseReport", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Time", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Rcpt", "Docs": "", "Typewords": ["string"] }, { "Name": "ReporterFrom", "Docs": "", "Typewords": ["string"] }, { "Name": "FeedbackType", "Docs": "", "Typewords": ["string"] }, { "Name": "UserAgent", "Docs": "", "Typewords": ["string"] }, { "Name": "SourceIP", "Docs": "", "Typewords": ["string"] }, { "Name": "ArrivalDate", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Incidents", "Docs": "", "Typewords": ["int32"] }, { "Name": "MessageID", "Docs": "", "Typewords": ["string"] }, { "Name": "MailFrom", "Docs": "", "Typewords": ["string"] }, { "Name": "RcptTo", "Docs": "", "Typewords": ["string"] }, { "Name": "From", "Docs": "", "Typewords": ["string"] }, { "Name": "Subject", "Docs": "", "Typewords": ["string"] }, { "Name": "Account", "Docs": "", "Typewords": ["string"] }, { "Name": "QueueIDs", "Docs": "", "Typewords": ["[]", "int64"] }, { "Name": "Recipients", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Suppressed", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Handled", "Docs": "", "Typewords": ["bool"] }] },
+		"LineEndingSource": { "Name": "LineEndingSource", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "RemoteIP", "Docs": "", "Typewords": ["string"] }, { "Name": "EHLO", "Docs": "", "Typewords": ["string"] }, { "Name": "MailFromDomain", "Docs": "", "Typewords": ["string"] }, { "Name": "Submission", "Docs": "", "Typewords": ["bool"] }, { "Name": "Messages", "Docs": "", "Typewords": ["int64"] }, { "Name": "BareCR", "Docs": "", "Typewords": ["int64"] }, { "Name": "BareLF", "Docs": "", "Typewords": ["int64"] }, { "Name": "BadDataEnd", "Docs": "", "Typewords": ["int64"] }, { "Name": "Rejected", "Docs": "", "Typewords": ["int64"] }, { "Name": "First", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Last", "Docs": "", "Typewords": ["timestamp"] }] },
+		"AutoBanEntry": { "Name": "AutoBanEntry", "Docs": "", "Fields": [{ "Name": "Network", "Docs": "", "Typewords": ["string"] }, { "Name": "Reason", "Docs": "", "Typewords": ["string"] }, { "Name": "Failures", "Docs": "", "Typewords": ["int32"] }, { "Name": "Start", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "End", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Refused", "Docs": "", "Typewords": ["int64"] }] },
+		"MessageEvent": { "Name": "MessageEvent", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Time", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "MessageID", "Docs": "", "Typewords": ["string"] }, { "Name": "QueueID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Cid", "Docs": "", "Typewords": ["int64"] }, { "Name": "Kind", "Docs": "", "Typewords": ["EventKind"] }, { "Name": "Account", "Docs": "", "Typewords": ["string"] }, { "Name": "Recipient", "Docs": "", "Typewords": ["string"] }, { "Name": "Remote", "Docs": "", "Typewords": ["string"] }, { "Name": "Result", "Docs": "", "Typewords": ["string"] }, { "Name": "Detail", "Docs": "", "Typewords": ["string"] }] },
+		"Usage": { "Name": "Usage", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Month", "Docs": "", "Typewords": ["string"] }, { "Name": "Account", "Docs": "", "Typewords": ["string"] }, { "Name": "Domain", "Docs": "", "Typewords": ["string"] }, { "Name": "MessagesReceived", "Docs": "", "Typewords": ["int64"] }, { "Name": "BytesReceived", "Docs": "", "Typewords": ["int64"] }, { "Name": "MessagesSent", "Docs": "", "Typewords": ["int64"] }, { "Name": "BytesSent", "Docs": "", "Typewords": ["int64"] }, { "Name": "StoredBytes", "Docs": "", "Typewords": ["int64"] }, { "Name": "Updated", "Docs": "", "Typewords": ["timestamp"] }] },
+		"StaticReload": { "Name": "StaticReload", "Docs": "", "Fields": [{ "Name": "Diff", "Docs": "", "Typewords": ["string"] }, { "Name": "Changed", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Restart", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Applied", "Docs": "", "Typewords": ["bool"] }] },
+		"LogFilter": { "Name": "LogFilter", "Docs": "", "Fields": [{ "Name": "Account", "Docs": "", "Typewords": ["string"] }, { "Name": "MessageID", "Docs": "", "Typewords": ["string"] }, { "Name": "Cid", "Docs": "", "Typewords": ["string"] }, { "Name": "Pkg", "Docs": "", "Typewords": ["string"] }, { "Name": "Level", "Docs": "", "Typewords": ["string"] }, { "Name": "Text", "Docs": "", "Typewords": ["string"] }, { "Name": "Max", "Docs": "", "Typewords": ["int32"] }] },
+		"LogEntry": { "Name": "LogEntry", "Docs": "", "Fields": [{ "Name": "Time", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Level", "Docs": "", "Typewords": ["string"] }, { "Name": "Pkg", "Docs": "", "Typewords": ["string"] }, { "Name": "Message", "Docs": "", "Typewords": ["string"] }, { "Name": "Fields", "Docs": "", "Typewords": ["[]", "LogField"] }] },
+		"LogField": { "Name": "LogField", "Docs": "", "Fields": [{ "Name": "Key", "Docs": "", "Typewords": ["string"] }, { "Name": "Value", "Docs": "", "Typewords": ["string"] }] },
+		"Quarantined": { "Name": "Quarantined", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Received", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Expires", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Account", "Docs": "", "Typewords": ["string"] }, { "Name": "Mailbox", "Docs": "", "Typewords": ["string"] }, { "Name": "Reason", "Docs": "", "Typewords": ["string"] }, { "Name": "RemoteIP", "Docs": "", "Typewords": ["string"] }, { "Name": "MailFrom", "Docs": "", "Typewords": ["string"] }, { "Name": "RcptTo", "Docs": "", "Typewords": ["string"] }, { "Name": "MsgFrom", "Docs": "", "Typewords": ["string"] }, { "Name": "Subject", "Docs": "", "Typewords": ["string"] }, { "Name": "MessageID", "Docs": "", "Typewords": ["string"] }, { "Name": "Size", "Docs": "", "Typewords": ["int64"] }, { "Name": "Digested", "Docs": "", "Typewords": ["timestamp"] }] },
+		"PasskeyCreationOptions": { "Name": "PasskeyCreationOptions", "Docs": "", "Fields": [{ "Name": "Challenge", "Docs": "", "Typewords": ["string"] }, { "Name": "RPID", "Docs": "", "Typewords": ["string"] }, { "Name": "RPName", "Docs": "", "Typewords": ["string"] }, { "Name": "UserID", "Docs": "", "Typewords": ["string"] }, { "Name": "UserName", "Docs": "", "Typewords": ["string"] }, { "Name": "UserDisplayName", "Docs": "", "Typewords": ["string"] }, { "Name": "ExcludeCredentialIDs", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Algorithms", "Docs": "", "Typewords": ["[]", "int32"] }, { "Name": "Timeout", "Docs": "", "Typewords": ["int32"] }] },
+		"PasskeyAttestation": { "Name": "PasskeyAttestation", "Docs": "", "Fields": [{ "Name": "ClientDataJSON", "Docs": "", "Typewords": ["string"] }, { "Name": "AttestationObject", "Docs": "", "Typewords": ["string"] }] },
+		"APIToken": { "Name": "APIToken", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Created", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Name", "Docs": "", "Typewords": ["string"] }, { "Name": "Role", "Docs": "", "Typewords": ["Role"] }, { "Name": "LastUsed", "Docs": "", "Typewords": ["timestamp"] }] },
+		"AuditEntry": { "Name": "AuditEntry", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Time", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Source", "Docs": "", "Typewords": ["string"] }, { "Name": "Actor", "Docs": "", "Typewords": ["string"] }, { "Name": "RemoteIP", "Docs": "", "Typewords": ["string"] }, { "Name": "Action", "Docs": "", "Typewords": ["string"] }, { "Name": "Params", "Docs": "", "Typewords": ["string"] }, { "Name": "Error", "Docs": "", "Typewords": ["string"] }] },
 		"ClientConfigs": { "Name": "ClientConfigs", "Docs": "", "Fields": [{ "Name": "Entries", "Docs": "", "Typewords": ["[]", "ClientConfigsEntry"] }] },
 		"ClientConfigsEntry": { "Name": "ClientConfigsEntry", "Docs": "", "Fields": [{ "Name": "Protocol", "Docs": "", "Typewords": ["string"] }, { "Name": "Host", "Docs": "", "Typewords": ["Domain"] }, { "Name": "Port", "Docs": "", "Typewords": ["int32"] }, { "Name": "Listener", "Docs": "", "Typewords": ["string"] }, { "Name": "Note", "Docs": "", "Typewords": ["string"] }] },
 		"HoldRule": { "Name": "HoldRule", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Account", "Docs": "", "Typewords": ["string"] }, { "Name": "SenderDomain", "Docs": "", "Typewords": ["Domain"] }, { "Name": "RecipientDomain", "Docs": "", "Typewords": ["Domain"] }, { "Name": "SenderDomainStr", "Docs": "", "Typewords": ["string"] }, { "Name": "RecipientDomainStr", "Docs": "", "Typewords": ["string"] }] },
 		"Filter": { "Name": "Filter", "Docs": "", "Fields": [{ "Name": "Max", "Docs": "", "Typewords": ["int32"] }, { "Name": "IDs", "Docs": "", "Typewords": ["[]", "int64"] }, { "Name": "Account", "Docs": "", "Typewords": ["string"] }, { "Name": "From", "Docs": "", "Typewords": ["string"] }, { "Name": "To", "Docs": "", "Typewords": ["string"] }, { "Name": "Hold", "Docs": "", "Typewords": ["nullable", "bool"] }, { "Name": "Submitted", "Docs": "", "Typewords": ["string"] }, { "Name": "NextAttempt", "Docs": "", "Typewords": ["string"] }, { "Name": "Transport", "Docs": "", "Typewords": ["nullable", "string"] }] },
 		"Sort": { "Name": "Sort", "Docs": "", "Fields": [{ "Name": "Field", "Docs": "", "Typewords": ["string"] }, { "Name": "LastID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Last", "Docs": "", "Typewords": ["any"] }, { "Name": "Asc", "Docs": "", "Typewords": ["bool"] }] },
-		"Msg": { "Name": "Msg", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "BaseID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Queued", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Hold", "Docs": "", "Typewords": ["bool"] }, { "Name": "SenderAccount", "Docs": "", "Typewords": ["string"] }, { "Name": "SenderLocalpart", "Docs": "", "Typewords": ["Localpart"] }, { "Name": "SenderDomain", "Docs": "", "Typewords": ["IPDomain"] }, { "Name": "SenderDomainStr", "Docs": "", "Typewords": ["string"] }, { "Name": "FromID", "Docs": "", "Typewords": ["string"] }, { "Name": "RecipientLocalpart", "Docs": "", "Typewords": ["Localpart"] }, { "Name": "RecipientDomain", "Docs": "", "Typewords": ["IPDomain"] }, { "Name": "RecipientDomainStr", "Docs": "", "Typewords": ["string"] }, { "Name": "Attempts", "Docs": "", "Typewords": ["int32"] }, { "Name": "MaxAttempts", "Docs": "", "Typewords": ["int32"] }, { "Name": "DialedIPs", "Docs": "", "Typewords": ["{}", "[]", "IP"] }, { "Name": "NextAttempt", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "LastAttempt", "Docs": "", "Typewords": ["nullable", "timestamp"] }, { "Name": "Results", "Docs": "", "Typewords": ["[]", "MsgResult"] }, { "Name": "Has8bit", "Docs": "", "Typewords": ["bool"] }, { "Name": "SMTPUTF8", "Docs": "", "Typewords": ["bool"] }, { "Name": "IsDMARCReport", "Docs": "", "Typewords": ["bool"] }, { "Name": "IsTLSReport", "Docs": "", "Typewords": ["bool"] }, { "Name": "Size", "Docs": "", "Typewords": ["int64"] }, { "Name": "MessageID", "Docs": "", "Typewords": ["string"] }, { "Name": "MsgPrefix", "Docs": "", "Typewords": ["nullable", "string"] }, { "Name": "Subject", "Docs": "", "Typewords": ["string"] }, { "Name": "DSNUTF8", "Docs": "", "Typewords": ["nullable", "string"] }, { "Name": "Transport", "Docs": "", "Typewords": ["string"] }, { "Name": "RequireTLS", "Docs": "", "Typewords": ["nullable", "bool"] }, { "Name": "FutureReleaseRequest", "Docs": "", "Typewords": ["string"] }, { "Name": "Extra", "Docs": "", "Typewords": ["{}", "string"] }] },
+		"Msg": { "Name": "Msg", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "BaseID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Queued", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Hold", "Docs": "", "Typewords": ["bool"] }, { "Name": "HoldUntil", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "SenderAccount", "Docs": "", "Typewords": ["string"] }, { "Name": "SenderLocalpart", "Docs": "", "Typewords": ["Localpart"] }, { "Name": "SenderDomain", "Docs": "", "Typewords": ["IPDomain"] }, { "Name": "SenderDomainStr", "Docs": "", "Typewords": ["string"] }, { "Name": "FromID", "Docs": "", "Typewords": ["string"] }, { "Name": "RecipientLocalpart", "Docs": "", "Typewords": ["Localpart"] }, { "Name": "RecipientDomain", "Docs": "", "Typewords": ["IPDomain"] }, { "Name": "RecipientDomainStr", "Docs": "", "Typewords": ["string"] }, { "Name": "Attempts", "Docs": "", "Typewords": ["int32"] }, { "Name": "MaxAttempts", "Docs": "", "Typewords": ["int32"] }, { "Name": "DialedIPs", "Docs": "", "Typewords": ["{}", "[]", "IP"] }, { "Name": "NextAttempt", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "LastAttempt", "Docs": "", "Typewords": ["nullable", "timestamp"] }, { "Name": "Results", "Docs": "", "Typewords": ["[]", "MsgResult"] }, { "Name": "Has8bit", "Docs": "", "Typewords": ["bool"] }, { "Name": "SMTPUTF8", "Docs": "", "Typewords": ["bool"] }, { "Name": "IsDMARCReport", "Docs": "", "Typewords": ["bool"] }, { "Name": "IsTLSReport", "Docs": "", "Typewords": ["bool"] }, { "Name": "Size", "Docs": "", "Typewords": ["int64"] }, { "Name": "MessageID", "Docs": "", "Typewords": ["string"] }, { "Name": "MsgPrefix", "Docs": "", "Typewords": ["nullable", "string"] }, { "Name": "Subject", "Docs": "", "Typewords": ["string"] }, { "Name": "DSNUTF8", "Docs": "", "Typewords": ["nullable", "string"] }, { "Name": "Transport", "Docs": "", "Typewords": ["string"] }, { "Name": "RequireTLS", "Docs": "", "Typewords": ["nullable", "bool"] }, { "Name": "FutureReleaseRequest", "Docs": "", "Typewords": ["string"] }, { "Name": "Extra", "Docs": "", "Typewords": ["{}", "string"] }, { "Name": "TraceParent", "Docs": "", "Typewords": ["string"] }, { "Name": "XClientAddr", "Docs": "", "Typewords": ["string"] }, { "Name": "XClientHelo", "Docs": "", "Typewords": ["string"] }] },
 		"IPDomain": { "Name": "IPDomain", "Docs": "", "Fields": [{ "Name": "IP", "Docs": "", "Typewords": ["IP"] }, { "Name": "Domain", "Docs": "", "Typewords": ["Domain"] }] },
 		"MsgResult": { "Name": "MsgResult", "Docs": "", "Fields": [{ "Name": "Start", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Duration", "Docs": "", "Typewords": ["int64"] }, { "Name": "Success", "Docs": "", "Typewords": ["bool"] }, { "Name": "Code", "Docs": "", "Typewords": ["int32"] }, { "Name": "Secode", "Docs": "", "Typewords": ["string"] }, { "Name": "Error", "Docs": "", "Typewords": ["string"] }] },
 		"RetiredFilter": { "Name": "RetiredFilter", "Docs": "", "Fields": [{ "Name": "Max", "Docs": "", "Typewords": ["int32"] }, { "Name": "IDs", "Docs": "", "Typewords": ["[]", "int64"] }, { "Name": "Account", "Docs": "", "Typewords": ["string"] }, { "Name": "From", "Docs": "", "Typewords": ["string"] }, { "Name": "To", "Docs": "", "Typewords": ["string"] }, { "Name": "Submitted", "Docs": "", "Typewords": ["string"] }, { "Name": "LastActivity", "Docs": "", "Typewords": ["string"] }, { "Name": "Transport", "Docs": "", "Typewords": ["nullable", "string"] }, { "Name": "Success", "Docs": "", "Typewords": ["nullable", "bool"] }] },
@@ -434,10 +547,16 @@ var api;
 		"HookRetiredSort": { "Name": "HookRetiredSort", "Docs": "", "Fields": [{ "Name": "Field", "Docs": "", "Typewords": ["string"] }, { "Name": "LastID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Last", "Docs": "", "Typewords": ["any"] }, { "Name": "Asc", "Docs": "", "Typewords": ["bool"] }] },
 		"HookRetired": { "Name": "HookRetired", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "QueueMsgID", "Docs": "", "Typewords": ["int64"] }, { "Name": "FromID", "Docs": "", "Typewords": ["string"] }, { "Name": "MessageID", "Docs": "", "Typewords": ["string"] }, { "Name": "Subject", "Docs": "", "Typewords": ["string"] }, { "Name": "Extra", "Docs": "", "Typewords": ["{}", "string"] }, { "Name": "Account", "Docs": "", "Typewords": ["string"] }, { "Name": "URL", "Docs": "", "Typewords": ["string"] }, { "Name": "Authorization", "Docs": "", "Typewords": ["bool"] }, { "Name": "IsIncoming", "Docs": "", "Typewords": ["bool"] }, { "Name": "OutgoingEvent", "Docs": "", "Typewords": ["string"] }, { "Name": "Payload", "Docs": "", "Typewords": ["string"] }, { "Name": "Submitted", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "SupersededByID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Attempts", "Docs": "", "Typewords": ["int32"] }, { "Name": "Results", "Docs": "", "Typewords": ["[]", "HookResult"] }, { "Name": "Success", "Docs": "", "Typewords": ["bool"] }, { "Name": "LastActivity", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "KeepUntil", "Docs": "", "Typewords": ["timestamp"] }] },
 		"WebserverConfig": { "Name": "WebserverConfig", "Docs": "", "Fields": [{ "Name": "WebDNSDomainRedirects", "Docs": "", "Typewords": ["[]", "[]", "Domain"] }, { "Name": "WebDomainRedirects", "Docs": "", "Typewords": ["[]", "[]", "string"] }, { "Name": "WebHandlers", "Docs": "", "Typewords": ["[]", "WebHandler"] }] },
-		"WebHandler": { "Name": "WebHandler", "Docs": "", "Fields": [{ "Name": "LogName", "Docs": "", "Typewords": ["string"] }, { "Name": "Domain", "Docs": "", "Typewords": ["string"] }, { "Name": "PathRegexp", "Docs": "", "Typewords": ["string"] }, { "Name": "DontRedirectPlainHTTP", "Docs": "", "Typewords": ["bool"] }, { "Name": "Compress", "Docs": "", "Typewords": ["bool"] }, { "Name": "WebStatic", "Docs": "", "Typewords": ["nullable", "WebStatic"] }, { "Name": "WebRedirect", "Docs": "", "Typewords": ["nullable", "WebRedirect"] }, { "Name": "WebForward", "Docs": "", "Typewords": ["nullable", "WebForward"] }, { "Name": "Name", "Docs": "", "Typewords": ["string"] }, { "Name": "DNSDomain", "Docs": "", "Typewords": ["Domain"] }] },
+		"WebHandler": { "Name": "WebHandler", "Docs": "", "Fields": [{ "Name": "LogName", "Docs": "", "Typewords": ["string"] }, { "Name": "Domain", "Docs": "", "Typewords": ["string"] }, { "Name": "PathRegexp", "Docs": "", "Typewords": ["string"] }, { "Name": "DontRedirectPlainHTTP", "Docs": "", "Typewords": ["bool"] }, { "Name": "Compress", "Docs": "", "Typewords": ["bool"] }, { "Name": "Access", "Docs": "", "Typewords": ["nullable", "WebAccess"] }, { "Name": "RateLimit", "Docs": "", "Typewords": ["nullable", "WebRateLimit"] }, { "Name": "Rules", "Docs": "", "Typewords": ["[]", "WebRule"] }, { "Name": "WebStatic", "Docs": "", "Typewords": ["nullable", "WebStatic"] }, { "Name": "WebRedirect", "Docs": "", "Typewords": ["nullable", "WebRedirect"] }, { "Name": "WebForward", "Docs": "", "Typewords": ["nullable", "WebForward"] }, { "Name": "Name", "Docs": "", "Typewords": ["string"] }, { "Name": "DNSDomain", "Docs": "", "Typewords": ["Domain"] }] },
+		"WebAccess": { "Name": "WebAccess", "Docs": "", "Fields": [{ "Name": "IPAllow", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "IPDeny", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "BasicAuth", "Docs": "", "Typewords": ["nullable", "WebBasicAuth"] }, { "Name": "OIDC", "Docs": "", "Typewords": ["nullable", "WebOIDCAuth"] }] },
+		"WebBasicAuth": { "Name": "WebBasicAuth", "Docs": "", "Fields": [{ "Name": "Realm", "Docs": "", "Typewords": ["string"] }, { "Name": "AllAccounts", "Docs": "", "Typewords": ["bool"] }, { "Name": "Accounts", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "HTPasswdFile", "Docs": "", "Typewords": ["string"] }] },
+		"WebOIDCAuth": { "Name": "WebOIDCAuth", "Docs": "", "Fields": [{ "Name": "Emails", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Domains", "Docs": "", "Typewords": ["[]", "string"] }] },
+		"WebRateLimit": { "Name": "WebRateLimit", "Docs": "", "Fields": [{ "Name": "Requests", "Docs": "", "Typewords": ["int64"] }, { "Name": "Window", "Docs": "", "Typewords": ["int64"] }] },
+		"WebRule": { "Name": "WebRule", "Docs": "", "Fields": [{ "Name": "PathRegexp", "Docs": "", "Typewords": ["string"] }, { "Name": "Methods", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "UserAgentRegexp", "Docs": "", "Typewords": ["string"] }, { "Name": "MaxBodySize", "Docs": "", "Typewords": ["int64"] }] },
 		"WebStatic": { "Name": "WebStatic", "Docs": "", "Fields": [{ "Name": "StripPrefix", "Docs": "", "Typewords": ["string"] }, { "Name": "Root", "Docs": "", "Typewords": ["string"] }, { "Name": "ListFiles", "Docs": "", "Typewords": ["bool"] }, { "Name": "ContinueNotFound", "Docs": "", "Typewords": ["bool"] }, { "Name": "ResponseHeaders", "Docs": "", "Typewords": ["{}", "string"] }] },
 		"WebRedirect": { "Name": "WebRedirect", "Docs": "", "Fields": [{ "Name": "BaseURL", "Docs": "", "Typewords": ["string"] }, { "Name": "OrigPathRegexp", "Docs": "", "Typewords": ["string"] }, { "Name": "ReplacePath", "Docs": "", "Typewords": ["string"] }, { "Name": "StatusCode", "Docs": "", "Typewords": ["int32"] }] },
-		"WebForward": { "Name": "WebForward", "Docs": "", "Fields": [{ "Name": "StripPath", "Docs": "", "Typewords": ["bool"] }, { "Name": "URL", "Docs": "", "Typewords": ["string"] }, { "Name": "ResponseHeaders", "Docs": "", "Typewords": ["{}", "string"] }] },
+		"WebForward": { "Name": "WebForward", "Docs": "", "Fields": [{ "Name": "StripPath", "Docs": "", "Typewords": ["bool"] }, { "Name": "URL", "Docs": "", "Typewords": ["string"] }, { "Name": "ResponseHeaders", "Docs": "", "Typewords": ["{}", "string"] }, { "Name": "HTTP2", "Docs": "", "Typewords": ["bool"] }, { "Name": "DialTimeout", "Docs": "", "Typewords": ["int64"] }, { "Name": "ResponseTimeout", "Docs": "", "Typewords": ["int64"] }, { "Name": "RequestHeaders", "Docs": "", "Typewords": ["{}", "string"] }, { "Name": "RequestHeadersRemove", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "RequestHeaderRewrites", "Docs": "", "Typewords": ["[]", "WebHeaderRewrite"] }, { "Name": "ResponseHeadersRemove", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "ResponseHeaderRewrites", "Docs": "", "Typewords": ["[]", "WebHeaderRewrite"] }] },
+		"WebHeaderRewrite": { "Name": "WebHeaderRewrite", "Docs": "", "Fields": [{ "Name": "Header", "Docs": "", "Typewords": ["string"] }, { "Name": "ValueRegexp", "Docs": "", "Typewords": ["string"] }, { "Name": "Replace", "Docs": "", "Typewords": ["string"] }] },
 		"Transport": { "Name": "Transport", "Docs": "", "Fields": [{ "Name": "Submissions", "Docs": "", "Typewords": ["nullable", "TransportSMTP"] }, { "Name": "Submission", "Docs": "", "Typewords": ["nullable", "TransportSMTP"] }, { "Name": "SMTP", "Docs": "", "Typewords": ["nullable", "TransportSMTP"] }, { "Name": "Socks", "Docs": "", "Typewords": ["nullable", "TransportSocks"] }, { "Name": "Direct", "Docs": "", "Typewords": ["nullable", "TransportDirect"] }] },
 		"TransportSMTP": { "Name": "TransportSMTP", "Docs": "", "Fields": [{ "Name": "Host", "Docs": "", "Typewords": ["string"] }, { "Name": "Port", "Docs": "", "Typewords": ["int32"] }, { "Name": "STARTTLSInsecureSkipVerify", "Docs": "", "Typewords": ["bool"] }, { "Name": "NoSTARTTLS", "Docs": "", "Typewords": ["bool"] }, { "Name": "Auth", "Docs": "", "Typewords": ["nullable", "SMTPAuth"] }] },
 		"SMTPAuth": { "Name": "SMTPAuth", "Docs": "", "Fields": [{ "Name": "Username", "Docs": "", "Typewords": ["string"] }, { "Name": "Password", "Docs": "", "Typewords": ["string"] }, { "Name": "Mechanisms", "Docs": "", "Typewords": ["[]", "string"] }] },
@@ -448,7 +567,12 @@ var api;
 		"SuppressAddress": { "Name": "SuppressAddress", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Inserted", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "ReportingAddress", "Docs": "", "Typewords": ["string"] }, { "Name": "Until", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Comment", "Docs": "", "Typewords": ["string"] }] },
 		"TLSResult": { "Name": "TLSResult", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "PolicyDomain", "Docs": "", "Typewords": ["string"] }, { "Name": "DayUTC", "Docs": "", "Typewords": ["string"] }, { "Name": "RecipientDomain", "Docs": "", "Typewords": ["string"] }, { "Name": "Created", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Updated", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "IsHost", "Docs": "", "Typewords": ["bool"] }, { "Name": "SendReport", "Docs": "", "Typewords": ["bool"] }, { "Name": "SentToRecipientDomain", "Docs": "", "Typewords": ["bool"] }, { "Name": "RecipientDomainReportingAddresses", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "SentToPolicyDomain", "Docs": "", "Typewords": ["bool"] }, { "Name": "Results", "Docs": "", "Typewords": ["[]", "Result"] }] },
 		"TLSRPTSuppressAddress": { "Name": "TLSRPTSuppressAddress", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Inserted", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "ReportingAddress", "Docs": "", "Typewords": ["string"] }, { "Name": "Until", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Comment", "Docs": "", "Typewords": ["string"] }] },
-		"Dynamic": { "Name": "Dynamic", "Docs": "", "Fields": [{ "Name": "Domains", "Docs": "", "Typewords": ["{}", "ConfigDomain"] }, { "Name": "Accounts", "Docs": "", "Typewords": ["{}", "Account"] }, { "Name": "WebDomainRedirects", "Docs": "", "Typewords": ["{}", "string"] }, { "Name": "WebHandlers", "Docs": "", "Typewords": ["[]", "WebHandler"] }, { "Name": "Routes", "Docs": "", "Typewords": ["[]", "Route"] }, { "Name": "MonitorDNSBLs", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "MonitorDNSBLZones", "Docs": "", "Typewords": ["[]", "Domain"] }] },
+		"Dynamic": { "Name": "Dynamic", "Docs": "", "Fields": [{ "Name": "Domains", "Docs": "", "Typewords": ["{}", "ConfigDomain"] }, { "Name": "Accounts", "Docs": "", "Typewords": ["{}", "Account"] }, { "Name": "WebDomainRedirects", "Docs": "", "Typewords": ["{}", "string"] }, { "Name": "WebHandlers", "Docs": "", "Typewords": ["[]", "WebHandler"] }, { "Name": "Routes", "Docs": "", "Typewords": ["[]", "Route"] }, { "Name": "AddressRewrites", "Docs": "", "Typewords": ["[]", "AddressRewrite"] }, { "Name": "MonitorDNSBLs", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "Version", "Docs": "", "Typewords": ["int32"] }, { "Name": "MonitorDNSBLZones", "Docs": "", "Typewords": ["[]", "Domain"] }] },
+		"AdminScope": { "Name": "AdminScope", "Docs": "", "Fields": [{ "Name": "LoginAddress", "Docs": "", "Typewords": ["string"] }, { "Name": "Domains", "Docs": "", "Typewords": ["[]", "Domain"] }] },
+		"DomainSetup": { "Name": "DomainSetup", "Docs": "", "Fields": [{ "Name": "ID", "Docs": "", "Typewords": ["int64"] }, { "Name": "Created", "Docs": "", "Typewords": ["timestamp"] }, { "Name": "Domain", "Docs": "", "Typewords": ["string"] }, { "Name": "Sign", "Docs": "", "Typewords": ["[]", "string"] }, { "Name": "MTASTSEnforce", "Docs": "", "Typewords": ["bool"] }, { "Name": "LastCheck", "Docs": "", "Typewords": ["timestamp"] }] },
+		"DomainSetupStatus": { "Name": "DomainSetupStatus", "Docs": "", "Fields": [{ "Name": "Setup", "Docs": "", "Typewords": ["DomainSetup"] }, { "Name": "Records", "Docs": "", "Typewords": ["[]", "DomainSetupRecord"] }, { "Name": "Checks", "Docs": "", "Typewords": ["[]", "DomainSetupCheck"] }, { "Name": "Ready", "Docs": "", "Typewords": ["bool"] }] },
+		"DomainSetupRecord": { "Name": "DomainSetupRecord", "Docs": "", "Fields": [{ "Name": "Name", "Docs": "", "Typewords": ["string"] }, { "Name": "Type", "Docs": "", "Typewords": ["string"] }, { "Name": "Value", "Docs": "", "Typewords": ["string"] }, { "Name": "Zone", "Docs": "", "Typewords": ["string"] }, { "Name": "Check", "Docs": "", "Typewords": ["string"] }] },
+		"DomainSetupCheck": { "Name": "DomainSetupCheck", "Docs": "", "Fields": [{ "Name": "Name", "Docs": "", "Typewords": ["string"] }, { "Name": "Errors", "Docs": "", "Typewords": ["[]", "string"] }] },
 		"CSRFToken": { "Name": "CSRFToken", "Docs": "", "Values": null },
 		"DMARCPolicy": { "Name": "DMARCPolicy", "Docs": "", "Values": [{ "Name": "PolicyEmpty", "Value": "", "Docs": "" }, { "Name": "PolicyNone", "Value": "none", "Docs": "" }, { "Name": "PolicyQuarantine", "Value": "quarantine", "Docs": "" }, { "Name": "PolicyReject", "Value": "reject", "Docs": "" }] },
 		"Align": { "Name": "Align", "Docs": "", "Values": [{ "Name": "AlignStrict", "Value": "s", "Docs": "" }, { "Name": "AlignRelaxed", "Value": "r", "Docs": "" }] },
@@ -464,9 +588,13 @@ var api;
 		"DKIMResult": { "Name": "DKIMResult", "Docs": "", "Values": [{ "Name": "DKIMAbsent", "Value": "", "Docs": "" }, { "Name": "DKIMNone", "Value": "none", "Docs": "" }, { "Name": "DKIMPass", "Value": "pass", "Docs": "" }, { "Name": "DKIMFail", "Value": "fail", "Docs": "" }, { "Name": "DKIMPolicy", "Value": "policy", "Docs": "" }, { "Name": "DKIMNeutral", "Value": "neutral", "Docs": "" }, { "Name": "DKIMTemperror", "Value": "temperror", "Docs": "" }, { "Name": "DKIMPermerror", "Value": "permerror", "Docs": "" }] },
 		"SPFDomainScope": { "Name": "SPFDomainScope", "Docs": "", "Values": [{ "Name": "SPFDomainScopeAbsent", "Value": "", "Docs": "" }, { "Name": "SPFDomainScopeHelo", "Value": "helo", "Docs": "" }, { "Name": "SPFDomainScopeMailFrom", "Value": "mfrom", "Docs": "" }] },
 		"SPFResult": { "Name": "SPFResult", "Docs": "", "Values": [{ "Name": "SPFAbsent", "Value": "", "Docs": "" }, { "Name": "SPFNone", "Value": "none", "Docs": "" }, { "Name": "SPFNeutral", "Value": "neutral", "Docs": "" }, { "Name": "SPFPass", "Value": "pass", "Docs": "" }, { "Name": "SPFFail", "Value": "fail", "Docs": "" }, { "Name": "SPFSoftfail", "Value": "softfail", "Docs": "" }, { "Name": "SPFTemperror", "Value": "temperror", "Docs": "" }, { "Name": "SPFPermerror", "Value": "permerror", "Docs": "" }] },
+		"Role": { "Name": "Role", "Docs": "", "Values": [{ "Name": "RoleReadonly", "Value": "readonly", "Docs": "" }, { "Name": "RoleQueue", "Value": "queue", "Docs": "" }, { "Name": "RoleDomains", "Value": "domains", "Docs": "" }, { "Name": "RoleAdmin", "Value": "admin", "Docs": "" }] },
+		"EventKind": { "Name": "EventKind", "Docs": "", "Values": [{ "Name": "EventReceived", "Value": "received", "Docs": "" }, { "Name": "EventRuleset", "Value": "ruleset", "Docs": "" }, { "Name": "EventJunk", "Value": "junk", "Docs": "" }, { "Name": "EventDelivered", "Value": "delivered", "Docs": "" }, { "Name": "EventRejected", "Value": "rejected", "Docs": "" }, { "Name": "EventQuarantined", "Value": "quarantined", "Docs": "" }, { "Name": "EventDiscarded", "Value": "discarded", "Docs": "" }, { "Name": "EventQueued", "Value": "queued", "Docs": "" }, { "Name": "EventAttempt", "Value": "attempt", "Docs": "" }, { "Name": "EventFailed", "Value": "failed", "Docs": "" }] },
 		"IP": { "Name": "IP", "Docs": "", "Values": [] },
 	};
 	api.parser = {
+		PasskeyRequestOptions: (v) => api.parse("PasskeyRequestOptions", v),
+		PasskeyAssertion: (v) => api.parse("PasskeyAssertion", v),
 		CheckResult: (v) => api.parse("CheckResult", v),
 		DNSSECResult: (v) => api.parse("DNSSECResult", v),
 		IPRevCheckResult: (v) => api.parse("IPRevCheckResult", v),
@@ -493,6 +621,7 @@ var api;
 		Pair: (v) => api.parse("Pair", v),
 		Policy: (v) => api.parse("Policy", v),
 		STSMX: (v) => api.parse("STSMX", v),
+		CertificateInfo: (v) => api.parse("CertificateInfo", v),
 		SRVConfCheckResult: (v) => api.parse("SRVConfCheckResult", v),
 		SRV: (v) => api.parse("SRV", v),
 		AutoconfCheckResult: (v) => api.parse("AutoconfCheckResult", v),
@@ -504,6 +633,7 @@ var api;
 		Canonicalization: (v) => api.parse("Canonicalization", v),
 		DMARC: (v) => api.parse("DMARC", v),
 		MTASTS: (v) => api.parse("MTASTS", v),
+		Status: (v) => api.parse("Status", v),
 		TLSRPT: (v) => api.parse("TLSRPT", v),
 		Route: (v) => api.parse("Route", v),
 		Alias: (v) => api.parse("Alias", v),
@@ -511,6 +641,12 @@ var api;
 		Address: (v) => api.parse("Address", v),
 		Destination: (v) => api.parse("Destination", v),
 		Ruleset: (v) => api.parse("Ruleset", v),
+		DomainAuth: (v) => api.parse("DomainAuth", v),
+		LDAPAuth: (v) => api.parse("LDAPAuth", v),
+		PAMAuth: (v) => api.parse("PAMAuth", v),
+		Footer: (v) => api.parse("Footer", v),
+		PasswordPolicy: (v) => api.parse("PasswordPolicy", v),
+		AddressRewrite: (v) => api.parse("AddressRewrite", v),
 		Account: (v) => api.parse("Account", v),
 		OutgoingWebhook: (v) => api.parse("OutgoingWebhook", v),
 		IncomingWebhook: (v) => api.parse("IncomingWebhook", v),
@@ -541,6 +677,25 @@ var api;
 		SPFAuthResult: (v) => api.parse("SPFAuthResult", v),
 		DMARCSummary: (v) => api.parse("DMARCSummary", v),
 		Reverse: (v) => api.parse("Reverse", v),
+		ProtocolSession: (v) => api.parse("ProtocolSession", v),
+		Passkey: (v) => api.parse("Passkey", v),
+		AccountDeletion: (v) => api.parse("AccountDeletion", v),
+		SubmissionIncident: (v) => api.parse("SubmissionIncident", v),
+		SpamtrapHit: (v) => api.parse("SpamtrapHit", v),
+		AbuseReport: (v) => api.parse("AbuseReport", v),
+		LineEndingSource: (v) => api.parse("LineEndingSource", v),
+		AutoBanEntry: (v) => api.parse("AutoBanEntry", v),
+		MessageEvent: (v) => api.parse("MessageEvent", v),
+		Usage: (v) => api.parse("Usage", v),
+		StaticReload: (v) => api.parse("StaticReload", v),
+		LogFilter: (v) => api.parse("LogFilter", v),
+		LogEntry: (v) => api.parse("LogEntry", v),
+		LogField: (v) => api.parse("LogField", v),
+		Quarantined: (v) => api.parse("Quarantined", v),
+		PasskeyCreationOptions: (v) => api.parse("PasskeyCreationOptions", v),
+		PasskeyAttestation: (v) => api.parse("PasskeyAttestation", v),
+		APIToken: (v) => api.parse("APIToken", v),
+		AuditEntry: (v) => api.parse("AuditEntry", v),
 		ClientConfigs: (v) => api.parse("ClientConfigs", v),
 		ClientConfigsEntry: (v) => api.parse("ClientConfigsEntry", v),
 		HoldRule: (v) => api.parse("HoldRule", v),
@@ -561,9 +716,15 @@ var api;
 		HookRetired: (v) => api.parse("HookRetired", v),
 		WebserverConfig: (v) => api.parse("WebserverConfig", v),
 		WebHandler: (v) => api.parse("WebHandler", v),
+		WebAccess: (v) => api.parse("WebAccess", v),
+		WebBasicAuth: (v) => api.parse("WebBasicAuth", v),
+		WebOIDCAuth: (v) => api.parse("WebOIDCAuth", v),
+		WebRateLimit: (v) => api.parse("WebRateLimit", v),
+		WebRule: (v) => api.parse("WebRule", v),
 		WebStatic: (v) => api.parse("WebStatic", v),
 		WebRedirect: (v) => api.parse("WebRedirect", v),
 		WebForward: (v) => api.parse("WebForward", v),
+		WebHeaderRewrite: (v) => api.parse("WebHeaderRewrite", v),
 		Transport: (v) => api.parse("Transport", v),
 		TransportSMTP: (v) => api.parse("TransportSMTP", v),
 		SMTPAuth: (v) => api.parse("SMTPAuth", v),
@@ -575,6 +736,11 @@ var api;
 		TLSResult: (v) => api.parse("TLSResult", v),
 		TLSRPTSuppressAddress: (v) => api.parse("TLSRPTSuppressAddress", v),
 		Dynamic: (v) => api.parse("Dynamic", v),
+		AdminScope: (v) => api.parse("AdminScope", v),
+		DomainSetup: (v) => api.parse("DomainSetup", v),
+		DomainSetupStatus: (v) => api.parse("DomainSetupStatus", v),
+		DomainSetupRecord: (v) => api.parse("DomainSetupRecord", v),
+		DomainSetupCheck: (v) => api.parse("DomainSetupCheck", v),
 		CSRFToken: (v) => api.parse("CSRFToken", v),
 		DMARCPolicy: (v) => api.parse("DMARCPolicy", v),
 		Align: (v) => api.parse("Align", v),
@@ -590,6 +756,8 @@ var api;
 		DKIMResult: (v) => api.parse("DKIMResult", v),
 		SPFDomainScope: (v) => api.parse("SPFDomainScope", v),
 		SPFResult: (v) => api.parse("SPFResult", v),
+		Role: (v) => api.parse("Role", v),
+		EventKind: (v) => api.parse("EventKind", v),
 		IP: (v) => api.parse("IP", v),
 	};
 	// Admin exports web API functions for the admin web interface. All its methods are
@@ -627,12 +795,65 @@ var api;
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
 		// Login returns a session token for the credentials, or fails with error code
-		// "user:badLogin". Call LoginPrep to get a loginToken.
-		async Login(loginToken, password) {
+		// "user:badLogin". Call LoginPrep to get a loginToken. If two-factor
+		// authentication is enabled and totpCode is empty, the error code is
+		// "user:totpRequired", and the login must be retried with a code.
+		// 
+		// The server admin logs in with an empty username and the admin password. Domain
+		// admins log in with the login address and password of their account.
+		async Login(loginToken, username, password, totpCode) {
 			const fn = "Login";
-			const paramTypes = [["string"], ["string"]];
+			const paramTypes = [["string"], ["string"], ["string"], ["string"]];
+			const returnTypes = [["CSRFToken"]];
+			const params = [loginToken, username, password, totpCode];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// PasskeyLoginPrep returns options for logging in with a passkey with
+		// navigator.credentials.get in the browser.
+		async PasskeyLoginPrep() {
+			const fn = "PasskeyLoginPrep";
+			const paramTypes = [];
+			const returnTypes = [["PasskeyRequestOptions"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// PasskeyLogin returns a session token for a login with a passkey, started with
+		// PasskeyLoginPrep, or fails with error code "user:loginFailed". Call LoginPrep
+		// to get a loginToken after the user selected a passkey.
+		async PasskeyLogin(loginToken, response) {
+			const fn = "PasskeyLogin";
+			const paramTypes = [["string"], ["PasskeyAssertion"]];
 			const returnTypes = [["CSRFToken"]];
-			const params = [loginToken, password];
+			const params = [loginToken, response];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// OIDCEnabled returns whether single sign-on through an OpenID Connect identity
+		// provider is configured.
+		async OIDCEnabled() {
+			const fn = "OIDCEnabled";
+			const paramTypes = [];
+			const returnTypes = [["bool"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// OIDCLoginPrep starts a single sign-on login, returning the URL of the identity
+		// provider to navigate to. The identity provider sends the browser back to this
+		// page with "code" and "state" query string parameters, for OIDCLogin.
+		async OIDCLoginPrep() {
+			const fn = "OIDCLoginPrep";
+			const paramTypes = [];
+			const returnTypes = [["string"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// OIDCLogin returns a session token for a single sign-on login started with
+		// OIDCLoginPrep, or fails with error code "user:loginFailed". Call LoginPrep to
+		// get a loginToken.
+		async OIDCLogin(loginToken, code, state) {
+			const fn = "OIDCLogin";
+			const paramTypes = [["string"], ["string"], ["string"]];
+			const returnTypes = [["CSRFToken"]];
+			const params = [loginToken, code, state];
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
 		// Logout invalidates the session token.
@@ -652,7 +873,8 @@ var api;
 			const params = [domainName];
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
-		// Domains returns all configured domain names, in UTF-8 for IDNA domains.
+		// Domains returns all configured domain names, in UTF-8 for IDNA domains. For
+		// domain admins, only their domains are returned.
 		async Domains() {
 			const fn = "Domains";
 			const paramTypes = [];
@@ -692,7 +914,8 @@ var api;
 			const params = [domain];
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
-		// Accounts returns the names of all configured accounts.
+		// Accounts returns the names of all configured accounts. For domain admins, only
+		// accounts with a default domain they manage are returned.
 		async Accounts() {
 			const fn = "Accounts";
 			const paramTypes = [];
@@ -724,6 +947,15 @@ var api;
 			const params = [];
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
+		// ACMECertificates returns the certificates obtained through ACME, for all ACME
+		// providers, with the provider and certificate authority that issued them.
+		async ACMECertificates() {
+			const fn = "ACMECertificates";
+			const paramTypes = [];
+			const returnTypes = [["[]", "CertificateInfo"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
 		// TLSReports returns TLS reports overlapping with period start/end, for the given
 		// policy domain (or all domains if empty). The reports are sorted first by period
 		// end (most recent first), then by policy domain.
@@ -869,7 +1101,7 @@ var api;
 		}
 		// SetPassword saves a new password for an account, invalidating the previous password.
 		// Sessions are not interrupted, and will keep working. New login attempts must use the new password.
-		// Password must be at least 8 characters.
+		// Password must be at least 8 characters, and meet the password policy.
 		async SetPassword(accountName, password) {
 			const fn = "SetPassword";
 			const paramTypes = [["string"], ["string"]];
@@ -877,12 +1109,319 @@ var api;
 			const params = [accountName, password];
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
+		// AccountPasswordChangeRequired returns whether the user of the account must
+		// change the password.
+		async AccountPasswordChangeRequired(accountName) {
+			const fn = "AccountPasswordChangeRequired";
+			const paramTypes = [["string"]];
+			const returnTypes = [["bool"]];
+			const params = [accountName];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// AccountPasswordChangeRequire sets whether the user of the account must change
+		// the password, e.g. after it may have been compromised. Until then, the
+		// password is only accepted for the account web interface, and its web sessions
+		// are ended. App passwords keep working.
+		async AccountPasswordChangeRequire(accountName, required) {
+			const fn = "AccountPasswordChangeRequire";
+			const paramTypes = [["string"], ["bool"]];
+			const returnTypes = [];
+			const params = [accountName, required];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// AccountProtocolSessions returns the active and recent IMAP and SMTP submission
+		// sessions of an account.
+		async AccountProtocolSessions(accountName) {
+			const fn = "AccountProtocolSessions";
+			const paramTypes = [["string"]];
+			const returnTypes = [["[]", "ProtocolSession"]];
+			const params = [accountName];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// AccountProtocolSessionClose closes an active IMAP or SMTP submission session
+		// of an account. If id is 0, all active sessions of the account are closed.
+		async AccountProtocolSessionClose(accountName, id) {
+			const fn = "AccountProtocolSessionClose";
+			const paramTypes = [["string"], ["int64"]];
+			const returnTypes = [["int32"]];
+			const params = [accountName, id];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// AccountTOTPReset disables two-factor authentication for an account, e.g. when
+		// the user lost access to the authenticator app and recovery codes. The user can
+		// then log in with just the password, and set up two-factor authentication again.
+		async AccountTOTPReset(accountName) {
+			const fn = "AccountTOTPReset";
+			const paramTypes = [["string"]];
+			const returnTypes = [];
+			const params = [accountName];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// AccountPasskeys returns the passkeys of an account.
+		async AccountPasskeys(accountName) {
+			const fn = "AccountPasskeys";
+			const paramTypes = [["string"]];
+			const returnTypes = [["[]", "Passkey"]];
+			const params = [accountName];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// AccountPasskeysReset removes all passkeys of an account, e.g. when the user
+		// lost access to devices.
+		async AccountPasskeysReset(accountName) {
+			const fn = "AccountPasskeysReset";
+			const paramTypes = [["string"]];
+			const returnTypes = [["int32"]];
+			const params = [accountName];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// AccountDeletions returns the accounts with a pending deletion.
+		async AccountDeletions() {
+			const fn = "AccountDeletions";
+			const paramTypes = [];
+			const returnTypes = [["[]", "AccountDeletion"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// AccountDeletionRequest requests deletion of an account, disabling logins
+		// immediately, and purging the account and all its data after the grace period.
+		async AccountDeletionRequest(accountName, grace) {
+			const fn = "AccountDeletionRequest";
+			const paramTypes = [["string"], ["int64"]];
+			const returnTypes = [["AccountDeletion"]];
+			const params = [accountName, grace];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// AccountDeletionCancel cancels a pending deletion of an account, enabling logins
+		// again.
+		async AccountDeletionCancel(accountName) {
+			const fn = "AccountDeletionCancel";
+			const paramTypes = [["string"]];
+			const returnTypes = [];
+			const params = [accountName];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// AccountDeletionPurge purges an account with a pending deletion now, without
+		// waiting for the grace period to end.
+		async AccountDeletionPurge(accountName) {
+			const fn = "AccountDeletionPurge";
+			const paramTypes = [["string"]];
+			const returnTypes = [];
+			const params = [accountName];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// SubmissionIncidents returns the most recent anomalies detected in submissions
+		// by an account, most recent first.
+		async SubmissionIncidents(accountName, max) {
+			const fn = "SubmissionIncidents";
+			const paramTypes = [["string"], ["int32"]];
+			const returnTypes = [["[]", "SubmissionIncident"]];
+			const params = [accountName, max];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// SubmissionThrottleClear ends throttling of submissions for an account before
+		// the throttle duration has passed. It returns the number of throttles cleared.
+		async SubmissionThrottleClear(accountName) {
+			const fn = "SubmissionThrottleClear";
+			const paramTypes = [["string"]];
+			const returnTypes = [["int32"]];
+			const params = [accountName];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// SpamtrapHits returns the most recent messages sent to spamtrap addresses, most
+		// recent first, at most max if max > 0. Domain admins only see hits for spamtraps
+		// in their domains.
+		async SpamtrapHits(max) {
+			const fn = "SpamtrapHits";
+			const paramTypes = [["int32"]];
+			const returnTypes = [["[]", "SpamtrapHit"]];
+			const params = [max];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// AbuseReports returns the most recent abuse reports received at role addresses,
+		// most recent first, at most max if max > 0.
+		async AbuseReports(max) {
+			const fn = "AbuseReports";
+			const paramTypes = [["int32"]];
+			const returnTypes = [["[]", "AbuseReport"]];
+			const params = [max];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// AbuseReportSetHandled marks an abuse report as handled, or as needing attention
+		// again.
+		async AbuseReportSetHandled(reportID, handled) {
+			const fn = "AbuseReportSetHandled";
+			const paramTypes = [["int64"], ["bool"]];
+			const returnTypes = [];
+			const params = [reportID, handled];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// LineEndingSources returns the remote IPs that sent messages with line ending
+		// anomalies in the SMTP message data, most recent first, at most max if max > 0.
+		async LineEndingSources(max) {
+			const fn = "LineEndingSources";
+			const paramTypes = [["int32"]];
+			const returnTypes = [["[]", "LineEndingSource"]];
+			const params = [max];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// AutoBans returns the networks currently banned after many failed authentication
+		// attempts or protocol abuses, most recent first.
+		async AutoBans() {
+			const fn = "AutoBans";
+			const paramTypes = [];
+			const returnTypes = [["[]", "AutoBanEntry"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// AutoBanRemove removes the ban for a network, allowing connections from it again.
+		async AutoBanRemove(network) {
+			const fn = "AutoBanRemove";
+			const paramTypes = [["string"]];
+			const returnTypes = [];
+			const params = [network];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// MessageTrace returns the delivery history of a message, oldest first. The id is
+		// a Message-ID, with or without <>, or the ID of a message in the queue.
+		async MessageTrace(id) {
+			const fn = "MessageTrace";
+			const paramTypes = [["string"]];
+			const returnTypes = [["[]", "MessageEvent"]];
+			const params = [id];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// UsageList returns the monthly usage of accounts and domains: messages
+		// received and sent, their total sizes, and the highest total size of stored
+		// messages. Start and end are months like "2026-10", or empty. Group is empty for
+		// usage per month, account and domain, "account" for sums per month and account,
+		// or "domain" for sums per month and domain.
+		async UsageList(start, end, account, domain, group) {
+			const fn = "UsageList";
+			const paramTypes = [["string"], ["string"], ["string"], ["string"], ["string"]];
+			const returnTypes = [["[]", "Usage"]];
+			const params = [start, end, account, domain, group];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// Quarantined returns the messages held in quarantine, most recent first,
+		// optionally only for an account.
+		async Quarantined(accountName) {
+			const fn = "Quarantined";
+			const paramTypes = [["string"]];
+			const returnTypes = [["[]", "Quarantined"]];
+			const params = [accountName];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// QuarantineHeaders returns the header section of a quarantined message.
+		async QuarantineHeaders(id) {
+			const fn = "QuarantineHeaders";
+			const paramTypes = [["int64"]];
+			const returnTypes = [["string"]];
+			const params = [id];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// QuarantineRelease delivers a quarantined message to the mailbox it was destined
+		// for, and removes it from the quarantine.
+		async QuarantineRelease(id) {
+			const fn = "QuarantineRelease";
+			const paramTypes = [["int64"]];
+			const returnTypes = [];
+			const params = [id];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// QuarantineRemove removes a quarantined message without delivering it.
+		async QuarantineRemove(id) {
+			const fn = "QuarantineRemove";
+			const paramTypes = [["int64"]];
+			const returnTypes = [];
+			const params = [id];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// Passkeys returns the passkeys for admin logins.
+		async Passkeys() {
+			const fn = "Passkeys";
+			const paramTypes = [];
+			const returnTypes = [["[]", "Passkey"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// PasskeyRegisterPrep returns options for registering a new passkey for admin
+		// logins with navigator.credentials.create in the browser. Finish registration
+		// with PasskeyRegister.
+		async PasskeyRegisterPrep() {
+			const fn = "PasskeyRegisterPrep";
+			const paramTypes = [];
+			const returnTypes = [["PasskeyCreationOptions"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// PasskeyRegister finishes registration of an admin passkey started with
+		// PasskeyRegisterPrep, with the response of navigator.credentials.create.
+		async PasskeyRegister(label, response) {
+			const fn = "PasskeyRegister";
+			const paramTypes = [["string"], ["PasskeyAttestation"]];
+			const returnTypes = [];
+			const params = [label, response];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// PasskeyRemove removes a passkey for admin logins.
+		async PasskeyRemove(id) {
+			const fn = "PasskeyRemove";
+			const paramTypes = [["int64"]];
+			const returnTypes = [];
+			const params = [id];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// APITokens returns the tokens for the admin API.
+		async APITokens() {
+			const fn = "APITokens";
+			const paramTypes = [];
+			const returnTypes = [["[]", "APIToken"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// APITokenAdd adds a token for the admin API with a role, and returns the token.
+		// The token is not stored, only a hash.
+		async APITokenAdd(name, role) {
+			const fn = "APITokenAdd";
+			const paramTypes = [["string"], ["Role"]];
+			const returnTypes = [["string"]];
+			const params = [name, role];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// APITokenRemove removes a token for the admin API.
+		async APITokenRemove(name) {
+			const fn = "APITokenRemove";
+			const paramTypes = [["string"]];
+			const returnTypes = [];
+			const params = [name];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// AuditList returns entries from the audit log of admin actions, most recent
+		// first. If source is non-empty, only entries from that source are returned:
+		// "webadmin", "adminapi" or "ctl". If max is 0, all entries are returned.
+		async AuditList(source, max) {
+			const fn = "AuditList";
+			const paramTypes = [["string"], ["int32"]];
+			const returnTypes = [["[]", "AuditEntry"]];
+			const params = [source, max];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
 		// AccountSettingsSave set new settings for an account that only an admin can set.
-		async AccountSettingsSave(accountName, maxOutgoingMessagesPerDay, maxFirstTimeRecipientsPerDay, maxMsgSize, firstTimeSenderDelay) {
+		async AccountSettingsSave(accountName, maxOutgoingMessagesPerDay, maxFirstTimeRecipientsPerDay, maxAliases, maxMsgSize, firstTimeSenderDelay) {
 			const fn = "AccountSettingsSave";
-			const paramTypes = [["string"], ["int32"], ["int32"], ["int64"], ["bool"]];
+			const paramTypes = [["string"], ["int32"], ["int32"], ["int32"], ["int64"], ["bool"]];
 			const returnTypes = [];
-			const params = [accountName, maxOutgoingMessagesPerDay, maxFirstTimeRecipientsPerDay, maxMsgSize, firstTimeSenderDelay];
+			const params = [accountName, maxOutgoingMessagesPerDay, maxFirstTimeRecipientsPerDay, maxAliases, maxMsgSize, firstTimeSenderDelay];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// AccountJunkFilterSharedSave saves whether the account contributes to the shared
+		// junk filter, and the weight of the shared junk filter when classifying incoming
+		// messages for the account.
+		async AccountJunkFilterSharedSave(accountName, contribute, sharedWeight) {
+			const fn = "AccountJunkFilterSharedSave";
+			const paramTypes = [["string"], ["bool"], ["float64"]];
+			const returnTypes = [];
+			const params = [accountName, contribute, sharedWeight];
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
 		// ClientConfigsDomain returns configurations for email clients, IMAP and
@@ -1079,6 +1618,44 @@ var api;
 			const params = [pkg];
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
+		// LogLevelsReset resets the log levels to those in the config file, undoing
+		// changes made at runtime. Also done on SIGHUP.
+		async LogLevelsReset() {
+			const fn = "LogLevelsReset";
+			const paramTypes = [];
+			const returnTypes = [];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// ConfigReloadPreview returns the changes in the config file mox.conf compared to
+		// the running configuration, without applying them.
+		async ConfigReloadPreview() {
+			const fn = "ConfigReloadPreview";
+			const paramTypes = [];
+			const returnTypes = [["StaticReload"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// ConfigReload reloads the config file mox.conf, applying changes that can take
+		// effect while running, and resetting log levels. Changes that require a restart
+		// are returned but not applied. If the config file has errors, nothing is applied.
+		// Also done on SIGHUP.
+		async ConfigReload() {
+			const fn = "ConfigReload";
+			const paramTypes = [];
+			const returnTypes = [["StaticReload"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// LogRecent returns log entries kept in memory that match the filter, most
+		// recent first.
+		async LogRecent(filter) {
+			const fn = "LogRecent";
+			const paramTypes = [["LogFilter"]];
+			const returnTypes = [["[]", "LogEntry"]];
+			const params = [filter];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
 		// CheckUpdatesEnabled returns whether checking for updates is enabled.
 		async CheckUpdatesEnabled() {
 			const fn = "CheckUpdatesEnabled";
@@ -1270,6 +1847,24 @@ var api;
 			const params = [domainName, routes];
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
+		// AccountFooterSave saves the footer added to messages submitted by an account.
+		// A nil footer removes it.
+		async AccountFooterSave(accountName, footer) {
+			const fn = "AccountFooterSave";
+			const paramTypes = [["string"], ["nullable", "Footer"]];
+			const returnTypes = [];
+			const params = [accountName, footer];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// DomainFooterSave saves the footer added to messages submitted with a message
+		// From address in a domain. A nil footer removes it.
+		async DomainFooterSave(domainName, footer) {
+			const fn = "DomainFooterSave";
+			const paramTypes = [["string"], ["nullable", "Footer"]];
+			const returnTypes = [];
+			const params = [domainName, footer];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
 		// RoutesSave saves global routes.
 		async RoutesSave(routes) {
 			const fn = "RoutesSave";
@@ -1278,6 +1873,22 @@ var api;
 			const params = [routes];
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
+		// AddressRewritesSave saves the global address rewrite rules.
+		async AddressRewritesSave(rules) {
+			const fn = "AddressRewritesSave";
+			const paramTypes = [["[]", "AddressRewrite"]];
+			const returnTypes = [];
+			const params = [rules];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// DomainAddressRewritesSave saves the address rewrite rules for a domain.
+		async DomainAddressRewritesSave(domainName, rules) {
+			const fn = "DomainAddressRewritesSave";
+			const paramTypes = [["string"], ["[]", "AddressRewrite"]];
+			const returnTypes = [];
+			const params = [domainName, rules];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
 		// DomainDescriptionSave saves the description for a domain.
 		async DomainDescriptionSave(domainName, descr) {
 			const fn = "DomainDescriptionSave";
@@ -1286,6 +1897,14 @@ var api;
 			const params = [domainName, descr];
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
+		// DomainAdminsSave saves the login addresses of domain admins for a domain.
+		async DomainAdminsSave(domainName, admins) {
+			const fn = "DomainAdminsSave";
+			const paramTypes = [["string"], ["[]", "string"]];
+			const returnTypes = [];
+			const params = [domainName, admins];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
 		// DomainClientSettingsDomainSave saves the client settings domain for a domain.
 		async DomainClientSettingsDomainSave(domainName, clientSettingsDomain) {
 			const fn = "DomainClientSettingsDomainSave";
@@ -1294,6 +1913,23 @@ var api;
 			const params = [domainName, clientSettingsDomain];
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
+		// DomainSenderListsSave saves the allow/deny sender lists for incoming messages
+		// to a domain. Senders are email addresses or domains as "@domain".
+		async DomainSenderListsSave(domainName, allow, reject, junk) {
+			const fn = "DomainSenderListsSave";
+			const paramTypes = [["string"], ["[]", "string"], ["[]", "string"], ["[]", "string"]];
+			const returnTypes = [];
+			const params = [domainName, allow, reject, junk];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// DomainSpamtrapsSave saves the localparts of the spamtrap addresses of a domain.
+		async DomainSpamtrapsSave(domainName, localparts) {
+			const fn = "DomainSpamtrapsSave";
+			const paramTypes = [["string"], ["[]", "string"]];
+			const returnTypes = [];
+			const params = [domainName, localparts];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
 		// DomainLocalpartConfigSave saves the localpart catchall and case-sensitive
 		// settings for a domain.
 		async DomainLocalpartConfigSave(domainName, localpartCatchallSeparator, localpartCaseSensitive) {
@@ -1324,12 +1960,26 @@ var api;
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
 		// DomainMTASTSSave saves the MTASTS policy for a domain. If policyID is empty,
-		// no MTASTS policy is served.
-		async DomainMTASTSSave(domainName, policyID, mode, maxAge, mx) {
+		// no MTASTS policy is served. If the policy changes but policyID is the same as
+		// the currently configured policy ID, a new policy ID is generated, so remote
+		// mail servers fetch the new policy. The policy ID in effect is returned.
+		// 
+		// For a policy in mode testing, a non-zero enforceAfter causes the policy to be
+		// changed to mode enforce after that period without TLS failures reported.
+		async DomainMTASTSSave(domainName, policyID, mode, maxAge, mx, enforceAfter) {
 			const fn = "DomainMTASTSSave";
-			const paramTypes = [["string"], ["string"], ["Mode"], ["int64"], ["[]", "string"]];
-			const returnTypes = [];
-			const params = [domainName, policyID, mode, maxAge, mx];
+			const paramTypes = [["string"], ["string"], ["Mode"], ["int64"], ["[]", "string"], ["int64"]];
+			const returnTypes = [["string"]];
+			const params = [domainName, policyID, mode, maxAge, mx, enforceAfter];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// DomainMTASTSPromotion returns the status of automatic promotion of the MTA-STS
+		// policy of a domain from mode testing to mode enforce.
+		async DomainMTASTSPromotion(domainName) {
+			const fn = "DomainMTASTSPromotion";
+			const paramTypes = [["string"]];
+			const returnTypes = [["Status"]];
+			const params = [domainName];
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
 		// DomainDKIMAdd adds a DKIM selector for a domain, generating a new private
@@ -1366,11 +2016,11 @@ var api;
 			const params = [aliaslp, domainName, alias];
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
-		async AliasUpdate(aliaslp, domainName, postPublic, listMembers, allowMsgFrom) {
+		async AliasUpdate(aliaslp, domainName, postPublic, listMembers, allowMsgFrom, ownerAddress, moderated) {
 			const fn = "AliasUpdate";
-			const paramTypes = [["string"], ["string"], ["bool"], ["bool"], ["bool"]];
+			const paramTypes = [["string"], ["string"], ["bool"], ["bool"], ["bool"], ["string"], ["bool"]];
 			const returnTypes = [];
-			const params = [aliaslp, domainName, postPublic, listMembers, allowMsgFrom];
+			const params = [aliaslp, domainName, postPublic, listMembers, allowMsgFrom, ownerAddress, moderated];
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
 		async AliasRemove(aliaslp, domainName) {
@@ -1394,6 +2044,53 @@ var api;
 			const params = [aliaslp, domainName, addresses];
 			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
 		}
+		// AdminScope returns whether the session is for the server admin or a domain
+		// admin, and for domain admins the domains they can manage.
+		async AdminScope() {
+			const fn = "AdminScope";
+			const paramTypes = [];
+			const returnTypes = [["AdminScope"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// DomainSetupAdd adds a new domain like DomainAdd, but without enabling DKIM
+		// signing and MTA-STS mode enforce until its DNS records have been verified with
+		// DomainSetupCheck and the setup is activated with DomainSetupActivate.
+		async DomainSetupAdd(domain, accountName, localpart) {
+			const fn = "DomainSetupAdd";
+			const paramTypes = [["string"], ["string"], ["string"]];
+			const returnTypes = [];
+			const params = [domain, accountName, localpart];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// DomainSetups returns the domains with a setup that has not yet been activated.
+		async DomainSetups() {
+			const fn = "DomainSetups";
+			const paramTypes = [];
+			const returnTypes = [["[]", "DomainSetup"]];
+			const params = [];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// DomainSetupCheck checks the DNS records of a domain with a pending setup,
+		// returning the records to create and whether the setup can be activated.
+		async DomainSetupCheck(domainName) {
+			const fn = "DomainSetupCheck";
+			const paramTypes = [["string"]];
+			const returnTypes = [["DomainSetupStatus"]];
+			const params = [domainName];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
+		// DomainSetupActivate activates the pending setup of a domain after checking its
+		// DNS records: DKIM signing is enabled, and the MTA-STS policy is changed to mode
+		// enforce. The new MTA-STS policy ID is returned, the DNS record must be updated
+		// with it. Empty if there is no MTA-STS policy.
+		async DomainSetupActivate(domainName) {
+			const fn = "DomainSetupActivate";
+			const paramTypes = [["string"]];
+			const returnTypes = [["string"]];
+			const params = [domainName];
+			return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params);
+		}
 	}
 	api.Client = Client;
 	api.defaultBaseURL = (function () {
@@ -1746,12 +2443,75 @@ var api;
 	};
 })(api || (api = {}));
 // Javascript is generated from typescript, do not modify generated javascript because changes will be overwritten.
+// Conversion between base64url strings in the API and binary values for WebAuthn.
+const base64urlDecode = (s) => Uint8Array.from(atob(s.replace(/-/g, '+').replace(/_/g, '/')), c => c.charCodeAt(0));
+const base64urlEncode = (buf) => btoa(String.fromCharCode(...new Uint8Array(buf))).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+// passkeyLogin asks the browser for a passkey and logs in with it, returning the
+// CSRF token.
+const passkeyLogin = async () => {
+	const opts = await client.PasskeyLoginPrep();
+	const cred = await window.navigator.credentials.get({
+		publicKey: {
+			challenge: base64urlDecode(opts.Challenge),
+			rpId: opts.RPID,
+			timeout: opts.Timeout,
+			userVerification: 'required',
+		},
+	});
+	if (!cred) {
+		throw new Error('no passkey selected');
+	}
+	const resp = cred.response;
+	// The login token cookie is short-lived, so only request it now.
+	const loginToken = await client.LoginPrep();
+	return await client.PasskeyLogin(loginToken, {
+		CredentialID: cred.id,
+		ClientDataJSON: base64urlEncode(resp.clientDataJSON),
+		AuthenticatorData: base64urlEncode(resp.authenticatorData),
+		Signature: base64urlEncode(resp.signature),
+		UserHandle: resp.userHandle ? base64urlEncode(resp.userHandle) : '',
+	});
+};
+// passkeyCreate asks the browser to create a new passkey for registration.
+const passkeyCreate = async (opts) => {
+	const cred = await window.navigator.credentials.create({
+		publicKey: {
+			challenge: base64urlDecode(opts.Challenge),
+			rp: { id: opts.RPID, name: opts.RPName },
+			user: { id: base64urlDecode(opts.UserID), name: opts.UserName, displayName: opts.UserDisplayName },
+			pubKeyCredParams: (opts.Algorithms || []).map(alg => ({ type: 'public-key', alg: alg })),
+			excludeCredentials: (opts.ExcludeCredentialIDs || []).map(id => ({ type: 'public-key', id: base64urlDecode(id) })),
+			authenticatorSelection: { residentKey: 'required', userVerification: 'required' },
+			attestation: 'none',
+			timeout: opts.Timeout,
+		},
+	});
+	if (!cred) {
+		throw new Error('no passkey created');
+	}
+	const resp = cred.response;
+	return {
+		ClientDataJSON: base64urlEncode(resp.clientDataJSON),
+		AttestationObject: base64urlEncode(resp.attestationObject),
+	};
+};
 const login = async (reason) => {
-	return new Promise((resolve, _) => {
+	// Single sign-on is optional, don't prevent a login if we cannot find out.
+	let oidcEnabled = false;
+	try {
+		oidcEnabled = await client.OIDCEnabled();
+	}
+	catch (err) {
+		console.log('checking single sign-on', err);
+	}
+	return new Promise((resolve, _reject) => {
 		const origFocus = document.activeElement;
 		let reasonElem;
 		let fieldset;
+		let username;
 		let password;
+		let totpBox;
+		let totpCode;
 		const root = dom.div(style({ position: 'absolute', top: 0, right: 0, bottom: 0, left: 0, backgroundColor: '#eee', display: 'flex', alignItems: 'center', justifyContent: 'center', zIndex: '1', animation: 'fadein .15s ease-in' }), dom.div(reasonElem = reason ? dom.div(style({ marginBottom: '2ex', textAlign: 'center' }), reason) : dom.div(), dom.div(style({ backgroundColor: 'white', borderRadius: '.25em', padding: '1em', boxShadow: '0 0 20px rgba(0, 0, 0, 0.1)', border: '1px solid #ddd', maxWidth: '95vw', overflowX: 'auto', maxHeight: '95vh', overflowY: 'auto', marginBottom: '20vh' }), dom.form(async function submit(e) {
 			e.preventDefault();
 			e.stopPropagation();
@@ -1759,7 +2519,7 @@ const login = async (reason) => {
 			try {
 				fieldset.disabled = true;
 				const loginToken = await client.LoginPrep();
-				const token = await client.Login(loginToken, password.value);
+				const token = await client.Login(loginToken, username.value, password.value, totpCode.value);
 				try {
 					window.localStorage.setItem('webadmincsrftoken', token);
 				}
@@ -1773,17 +2533,96 @@ const login = async (reason) => {
 				resolve(token);
 			}
 			catch (err) {
+				if (err.code === 'user:totpRequired') {
+					fieldset.disabled = false;
+					totpBox.style.display = '';
+					totpCode.required = true;
+					totpCode.focus();
+					return;
+				}
 				console.log('login error', err);
 				window.alert('Error: ' + errmsg(err));
 			}
 			finally {
 				fieldset.disabled = false;
 			}
-		}, fieldset = dom.fieldset(dom.h1('Admin'), dom.label(style({ display: 'block', marginBottom: '2ex' }), dom.div('Password', style({ marginBottom: '.5ex' })), password = dom.input(attr.type('password'), attr.required(''))), dom.div(style({ textAlign: 'center' }), dom.submitbutton('Login')))))));
+		}, fieldset = dom.fieldset(dom.h1(_('Admin')), dom.label(style({ display: 'block', marginBottom: '2ex' }), dom.div(_('Email address'), attr.title('Only for domain admins, who log in with the email address and password of their account. Leave empty for the server admin.'), style({ marginBottom: '.5ex' })), username = dom.input(attr.autocomplete('username'), attr.placeholder('Empty for server admin'))), dom.label(style({ display: 'block', marginBottom: '2ex' }), dom.div(_('Password'), style({ marginBottom: '.5ex' })), password = dom.input(attr.type('password'), attr.required(''))), totpBox = dom.label(style({ display: 'none', marginBottom: '2ex' }), dom.div(_('Two-factor authentication code'), style({ marginBottom: '.5ex' })), totpCode = dom.input(attr.autocomplete('one-time-code'))), dom.div(style({ textAlign: 'center' }), dom.submitbutton(_('Login')), window.PublicKeyCredential ? [
+			' ',
+			dom.clickbutton(_('Login with passkey'), async function click() {
+				reasonElem.remove();
+				try {
+					fieldset.disabled = true;
+					const token = await passkeyLogin();
+					try {
+						window.localStorage.setItem('webadmincsrftoken', token);
+					}
+					catch (err) {
+						console.log('saving csrf token in localStorage', err);
+					}
+					root.remove();
+					if (origFocus && origFocus instanceof HTMLElement && origFocus.parentNode) {
+						origFocus.focus();
+					}
+					resolve(token);
+				}
+				catch (err) {
+					console.log('passkey login error', err);
+					window.alert('Error: ' + errmsg(err));
+				}
+				finally {
+					fieldset.disabled = false;
+				}
+			}),
+		] : [], oidcEnabled ? [
+			' ',
+			dom.clickbutton(_('Login with single sign-on'), async function click() {
+				try {
+					fieldset.disabled = true;
+					window.location.href = await client.OIDCLoginPrep();
+				}
+				catch (err) {
+					console.log('single sign-on error', err);
+					window.alert('Error: ' + errmsg(err));
+					fieldset.disabled = false;
+				}
+			}),
+		] : []))))));
 		document.body.appendChild(root);
-		password.focus();
+		username.focus();
 	});
 };
+// oidcLoginFinish finishes a single sign-on login when the identity provider sent
+// the browser back with an authorization code.
+const oidcLoginFinish = async () => {
+	const params = new URLSearchParams(window.location.search);
+	const code = params.get('code');
+	const state = params.get('state');
+	const error = params.get('error');
+	if (!state || (!code && !error)) {
+		return;
+	}
+	// Remove the parameters, they can only be used once.
+	window.history.replaceState(null, '', window.location.pathname + window.location.hash);
+	if (error) {
+		window.alert('Error: single sign-on failed: ' + (params.get('error_description') || error));
+		return;
+	}
+	try {
+		const loginToken = await client.LoginPrep();
+		const token = await client.OIDCLogin(loginToken, code || '', state);
+		try {
+			window.localStorage.setItem('webadmincsrftoken', token);
+		}
+		catch (err) {
+			console.log('saving csrf token in localStorage', err);
+		}
+		client.authState.token = token;
+	}
+	catch (err) {
+		console.log('single sign-on login error', err);
+		window.alert('Error: ' + errmsg(err));
+	}
+};
 // Popup shows kids in a centered div with white background on top of a
 // transparent overlay on top of the window. Clicking the overlay or hitting
 // Escape closes the popup. Scrollbars are automatically added to the div with
@@ -1831,6 +2670,8 @@ const localStorageRemove = (k) => {
 	}
 };
 const client = new api.Client().withOptions({ csrfHeader: 'x-mox-csrf', login: login }).withAuthToken(localStorageGet('webadmincsrftoken') || '');
+// Whether we are logged in as server admin, or as domain admin with limited access.
+let adminScope = { LoginAddress: '', Domains: [] };
 const check = async (elem, p) => {
 	try {
 		elem.disabled = true;
@@ -1863,7 +2704,10 @@ const crumbs = (...l) => {
 	document.title = l.map(e => crumbtext(e)).join(' - ');
 	const crumblink = (e) => typeof e === 'string' ? prewrap(e) : dom.a(e.text, attr.href(e.path));
 	return [
-		dom.div(style({ float: 'right' }), dom.clickbutton('Logout', attr.title('Logout, invalidating this session.'), async function click(e) {
+		dom.div(style({ float: 'right' }), dom.select(attr.title(_('Language of the user interface.')), dom.option(attr.value(''), _('Browser default')), i18nLanguages.map(l => dom.option(attr.value(l.Code), l.Name, l.Code === i18nPreference() ? attr.selected('') : [])), function change(e) {
+			i18nPreferenceSet(e.target.value);
+			window.location.reload();
+		}), ' ', dom.clickbutton(_('Logout'), attr.title(_('Logout, invalidating this session.')), async function click(e) {
 			const b = e.target;
 			try {
 				b.disabled = true;
@@ -2009,13 +2853,23 @@ const formatSize = (n) => {
 	}
 	return n + ' bytes';
 };
+const domainAdminIndex = async () => {
+	const accounts = await client.Accounts();
+	dom._kids(page, crumbs('Mox Admin'), dom.p('Logged in as domain admin ', prewrap(adminScope.LoginAddress), '.'), dom.p(dom.a('Accounts', attr.href('#accounts')), ' (' + (accounts || []).length + ')'), dom.p(dom.a('Quarantine', attr.href('#quarantine'))), dom.p(dom.a('Spamtrap hits', attr.href('#spamtraps'))), dom.h2('Domains'), (adminScope.Domains || []).length === 0 ? box(red, 'No domains') :
+		dom.ul((adminScope.Domains || []).map(d => dom.li(dom.a(attr.href('#domains/' + domainName(d)), domainString(d))))), footer);
+};
 const index = async () => {
-	const [domains, queueSize, hooksQueueSize, checkUpdatesEnabled, accounts] = await Promise.all([
+	if (adminScope.LoginAddress) {
+		await domainAdminIndex();
+		return;
+	}
+	const [domains, queueSize, hooksQueueSize, checkUpdatesEnabled, accounts, setups] = await Promise.all([
 		client.Domains(),
 		client.QueueSize(),
 		client.HookQueueSize(),
 		client.CheckUpdatesEnabled(),
 		client.Accounts(),
+		client.DomainSetups(),
 	]);
 	let fieldset;
 	let domain;
@@ -2025,19 +2879,24 @@ const index = async () => {
 	let recvID;
 	let cidElem;
 	dom._kids(page, crumbs('Mox Admin'), checkUpdatesEnabled ? [] : dom.p(box(yellow, 'Warning: Checking for updates has not been enabled in mox.conf (CheckUpdates: true).', dom.br(), 'Make sure you stay up to date through another mechanism!', dom.br(), 'You have a responsibility to keep the internet-connected software you run up to date and secure!', dom.br(), 'See ', link('https://updates.xmox.nl/changelog'))), dom.p(dom.a('Accounts', attr.href('#accounts')), dom.br(), dom.a('Queue', attr.href('#queue')), ' (' + queueSize + ')', dom.br(), dom.a('Webhook queue', attr.href('#webhookqueue')), ' (' + hooksQueueSize + ')', dom.br()), dom.h2('Domains'), (domains || []).length === 0 ? box(red, 'No domains') :
-		dom.ul((domains || []).map(d => dom.li(dom.a(attr.href('#domains/' + domainName(d)), domainString(d))))), dom.br(), dom.h2('Add domain'), dom.form(async function submit(e) {
+		dom.ul((domains || []).map(d => dom.li(dom.a(attr.href('#domains/' + domainName(d)), domainString(d))))), (setups || []).length === 0 ? [] : [
+		dom.br(),
+		dom.h2('Domain setups in progress'),
+		dom.p('Outgoing messages for these domains are not DKIM-signed until their DNS records are verified and the setup is activated.'),
+		dom.ul((setups || []).map(s => dom.li(dom.a(attr.href('#domains/' + s.Domain + '/setup'), s.Domain)))),
+	], dom.br(), dom.h2('Add domain'), dom.form(async function submit(e) {
 		e.preventDefault();
 		e.stopPropagation();
-		await check(fieldset, client.DomainAdd(domain.value, account.value, localpart.value));
-		window.location.hash = '#domains/' + domain.value;
-	}, fieldset = dom.fieldset(dom.label(style({ display: 'inline-block' }), dom.span('Domain', attr.title('Domain for incoming/outgoing email to add to mox. Can also be a subdomain of a domain already configured.')), dom.br(), domain = dom.input(attr.required(''))), ' ', dom.label(style({ display: 'inline-block' }), dom.span('Postmaster/reporting account', attr.title('Account that is considered the owner of this domain. If the account does not yet exist, it will be created and a a localpart is required for the initial email address.')), dom.br(), account = dom.input(attr.required(''), attr.list('accountList')), dom.datalist(attr.id('accountList'), (accounts || []).map(a => dom.option(a)))), ' ', dom.label(style({ display: 'inline-block' }), dom.span('Localpart (if new account)', attr.title('Must be set if and only if account does not yet exist. A localpart is the part before the "@"-sign of an email address. An account requires an email address, so creating a new account for a domain requires a localpart to form an initial email address.')), dom.br(), localpart = dom.input()), ' ', dom.submitbutton('Add domain', attr.title('Domain will be added and the config reloaded. Add the required DNS records after adding the domain.')))), dom.br(), dom.h2('Reports'), dom.div(dom.a('DMARC', attr.href('#dmarc/reports'))), dom.div(dom.a('TLS', attr.href('#tlsrpt/reports'))), dom.br(), dom.h2('Operations'), dom.div(dom.a('MTA-STS policies', attr.href('#mtasts'))), dom.div(dom.a('DMARC evaluations', attr.href('#dmarc/evaluations'))), dom.div(dom.a('TLS connection results', attr.href('#tlsrpt/results'))), dom.div(dom.a('DNSBL', attr.href('#dnsbl'))), dom.div(style({ marginTop: '.5ex' }), dom.form(async function submit(e) {
+		await check(fieldset, client.DomainSetupAdd(domain.value, account.value, localpart.value));
+		window.location.hash = '#domains/' + domain.value + '/setup';
+	}, fieldset = dom.fieldset(dom.label(style({ display: 'inline-block' }), dom.span('Domain', attr.title('Domain for incoming/outgoing email to add to mox. Can also be a subdomain of a domain already configured.')), dom.br(), domain = dom.input(attr.required(''))), ' ', dom.label(style({ display: 'inline-block' }), dom.span('Postmaster/reporting account', attr.title('Account that is considered the owner of this domain. If the account does not yet exist, it will be created and a a localpart is required for the initial email address.')), dom.br(), account = dom.input(attr.required(''), attr.list('accountList')), dom.datalist(attr.id('accountList'), (accounts || []).map(a => dom.option(a)))), ' ', dom.label(style({ display: 'inline-block' }), dom.span('Localpart (if new account)', attr.title('Must be set if and only if account does not yet exist. A localpart is the part before the "@"-sign of an email address. An account requires an email address, so creating a new account for a domain requires a localpart to form an initial email address.')), dom.br(), localpart = dom.input()), ' ', dom.submitbutton('Add domain', attr.title('Domain will be added with new DKIM keys and the config reloaded. Next, the DNS records to create are shown and checked. Outgoing messages are DKIM-signed once the records are valid and the setup is activated.')))), dom.br(), dom.h2('Reports'), dom.div(dom.a('DMARC', attr.href('#dmarc/reports'))), dom.div(dom.a('TLS', attr.href('#tlsrpt/reports'))), dom.div(dom.a('Abuse', attr.href('#abusereports'))), dom.br(), dom.h2('Operations'), dom.div(dom.a('MTA-STS policies', attr.href('#mtasts'))), dom.div(dom.a('DMARC evaluations', attr.href('#dmarc/evaluations'))), dom.div(dom.a('TLS connection results', attr.href('#tlsrpt/results'))), dom.div(dom.a('DNSBL', attr.href('#dnsbl'))), dom.div(dom.a('ACME certificates', attr.href('#acmecertificates'))), dom.div(dom.a('Quarantine', attr.href('#quarantine'))), dom.div(dom.a('Spamtrap hits', attr.href('#spamtraps'))), dom.div(dom.a('Line ending anomalies', attr.href('#lineendings'))), dom.div(dom.a('Automatic bans', attr.href('#autobans'))), dom.div(dom.a('Recent log', attr.href('#logs'))), dom.div(dom.a('Message trace', attr.href('#messagetrace'))), dom.div(dom.a('Usage', attr.href('#usage'))), dom.div(style({ marginTop: '.5ex' }), dom.form(async function submit(e) {
 		e.preventDefault();
 		e.stopPropagation();
 		dom._kids(cidElem);
 		await check(recvIDFieldset, client.LookupCid(recvID.value));
 	}, recvIDFieldset = dom.fieldset(dom.label('Received ID', attr.title('The ID in the Received header that was added during incoming delivery.')), ' ', recvID = dom.input(attr.required('')), ' ', dom.submitbutton('Lookup cid', attr.title('Logging about an incoming message includes an attribute "cid", a counter identifying the transaction related to delivery of the message. The ID in the received header is an encrypted cid, which this form decrypts, after which you can look it up in the logging.')), ' ', cidElem = dom.span()))), 
 	// todo: routing, globally, per domain and per account
-	dom.br(), dom.h2('Configuration'), dom.div(dom.a('Routes', attr.href('#routes'))), dom.div(dom.a('Webserver', attr.href('#webserver'))), dom.div(dom.a('Files', attr.href('#config'))), dom.div(dom.a('Log levels', attr.href('#loglevels'))), footer);
+	dom.br(), dom.h2('Configuration'), dom.div(dom.a('Routes', attr.href('#routes'))), dom.div(dom.a('Address rewrites', attr.href('#addressrewrites'))), dom.div(dom.a('Webserver', attr.href('#webserver'))), dom.div(dom.a('Files', attr.href('#config'))), dom.div(dom.a('Log levels', attr.href('#loglevels'))), dom.div(dom.a('Passkeys', attr.href('#passkeys'))), dom.div(dom.a('API tokens', attr.href('#apitokens'))), dom.div(dom.a('Audit log', attr.href('#auditlog'))), footer);
 };
 const globalRoutes = async () => {
 	const [transports, config] = await Promise.all([
@@ -2046,9 +2905,125 @@ const globalRoutes = async () => {
 	]);
 	dom._kids(page, crumbs(crumblink('Mox Admin', '#'), 'Routes'), RoutesEditor('global', transports, config.Routes || [], async (routes) => await client.RoutesSave(routes)));
 };
+const globalAddressRewrites = async () => {
+	const config = await client.Config();
+	dom._kids(page, crumbs(crumblink('Mox Admin', '#'), 'Address rewrites'), AddressRewritesEditor('global', config.AddressRewrites || [], async (rules) => await client.AddressRewritesSave(rules)));
+};
 const config = async () => {
 	const [staticPath, dynamicPath, staticText, dynamicText] = await client.ConfigFiles();
-	dom._kids(page, crumbs(crumblink('Mox Admin', '#'), 'Config'), dom.h2(staticPath), dom.pre(dom._class('literal'), staticText), dom.h2(dynamicPath), dom.pre(dom._class('literal'), dynamicText));
+	let reloadBox;
+	const showReload = (r) => {
+		const changed = r.Changed || [];
+		const restart = r.Restart || [];
+		dom._kids(reloadBox, r.Diff ? dom.pre(dom._class('literal'), r.Diff) : [], !r.Diff && changed.length === 0 && restart.length === 0 ? dom.p('No changes.') : [], changed.length > 0 ? dom.p((r.Applied ? 'Applied: ' : 'Will be applied: ') + changed.join(', ')) : [], restart.length > 0 ? dom.p('Only effective after a restart: ' + restart.join(', ')) : []);
+	};
+	dom._kids(page, crumbs(crumblink('Mox Admin', '#'), 'Config'), dom.h2(staticPath), dom.pre(dom._class('literal'), staticText), dom.p('Changes to this file can be applied without restart for most settings, but not for listeners, ACME and the data directory. The file is validated first, along with the domains file, and nothing changes if it has errors. Reloading also resets log levels to those in the file. A reload is also done on SIGHUP.'), dom.div(dom.clickbutton('Preview reload', attr.title('Show the changes in the config file compared to the running configuration, without applying them.'), async function click(e) {
+		const r = await check(e.target, client.ConfigReloadPreview());
+		showReload(r);
+	}), ' ', dom.clickbutton('Reload', attr.title('Apply changes in the config file that take effect without restart.'), async function click(e) {
+		const r = await check(e.target, client.ConfigReload());
+		showReload(r);
+	})), reloadBox = dom.div(), dom.h2(dynamicPath), dom.pre(dom._class('literal'), dynamicText));
+};
+const passkeys = async () => {
+	const passkeys = await client.Passkeys() || [];
+	const nowSecs = new Date().getTime() / 1000;
+	let fieldset;
+	let label;
+	dom._kids(page, crumbs(crumblink('Mox Admin', '#'), 'Passkeys'), dom.p('Passkeys let you log in to the admin web interface without password, with a device or security key that verifies it is you. No two-factor authentication code is needed when logging in with a passkey.'), dom.table(dom._class('hover'), dom.thead(dom.tr(dom.th('Label'), dom.th('Created'), dom.th('Last used'), dom.th('Action'))), dom.tbody(passkeys.length === 0 ? dom.tr(dom.td(attr.colspan('4'), '(None)')) : [], passkeys.map(pk => dom.tr(dom.td(pk.Label), dom.td(age(pk.Created, false, nowSecs)), dom.td(pk.LastUsed.getTime() > 0 ? age(pk.LastUsed, false, nowSecs) : 'Never'), dom.td(dom.clickbutton('Remove', async function click(e) {
+		if (!window.confirm('Are you sure you want to remove this passkey?')) {
+			return;
+		}
+		await check(e.target, client.PasskeyRemove(pk.ID));
+		window.location.reload(); // todo: reload less
+	})))))), dom.br(), window.PublicKeyCredential ?
+		dom.form(async function submit(e) {
+			e.preventDefault();
+			e.stopPropagation();
+			await check(fieldset, (async () => {
+				const opts = await client.PasskeyRegisterPrep();
+				const response = await passkeyCreate(opts);
+				await client.PasskeyRegister(label.value, response);
+			})());
+			window.location.reload(); // todo: reload less
+		}, fieldset = dom.fieldset(dom.label('Label ', label = dom.input(attr.required(''), attr.placeholder('e.g. laptop'))), ' ', dom.submitbutton('Add passkey'))) :
+		dom.p('Your browser does not support passkeys.'));
+};
+const apitokens = async () => {
+	const tokens = await client.APITokens() || [];
+	const nowSecs = new Date().getTime() / 1000;
+	let fieldset;
+	let name;
+	let role;
+	let tokenElem;
+	dom._kids(page, crumbs(crumblink('Mox Admin', '#'), 'API tokens'), dom.p('Tokens for the admin API, for provisioning domains, accounts, addresses and aliases, and managing the queue. The role of a token determines which methods it can call. The admin API must be enabled in a listener with AdminAPIHTTP or AdminAPIHTTPS.'), dom.table(dom._class('hover'), dom.thead(dom.tr(dom.th('Name'), dom.th('Role'), dom.th('Created'), dom.th('Last used'), dom.th('Action'))), dom.tbody(tokens.length === 0 ? dom.tr(dom.td(attr.colspan('5'), '(None)')) : [], tokens.map(t => dom.tr(dom.td(t.Name), dom.td(t.Role || 'admin'), dom.td(age(t.Created, false, nowSecs)), dom.td(t.LastUsed.getTime() > 0 ? age(t.LastUsed, false, nowSecs) : 'Never'), dom.td(dom.clickbutton('Remove', async function click(e) {
+		if (!window.confirm('Are you sure you want to remove this token? Systems using it can no longer call the admin API.')) {
+			return;
+		}
+		await check(e.target, client.APITokenRemove(t.Name));
+		window.location.reload(); // todo: reload less
+	})))))), dom.br(), dom.h2('Add token'), dom.form(async function submit(e) {
+		e.preventDefault();
+		e.stopPropagation();
+		const token = await check(fieldset, client.APITokenAdd(name.value, role.value));
+		dom._kids(tokenElem, dom.p('Token added. It is only shown once, store it now. Pass it to the admin API in an "Authorization: Bearer <token>" HTTP header.'), dom.pre(dom._class('literal'), token), dom.clickbutton('Done', function click() {
+			window.location.reload(); // todo: reload less
+		}));
+	}, fieldset = dom.fieldset(dom.label(style({ display: 'inline-block' }), 'Name', dom.br(), name = dom.input(attr.required(''), attr.placeholder('e.g. provisioning'))), ' ', dom.label(style({ display: 'inline-block' }), dom.span('Role', attr.title('readonly: only methods that make no changes.\nqueue: read-only methods, and managing the outgoing queue.\ndomains: read-only methods, and managing domains, accounts, addresses and aliases.\nadmin: all methods, including the audit log.')), dom.br(), role = dom.select(attr.required(''), Object.values(api.Role).map(r => dom.option(r, r === api.Role.RoleDomains ? attr.selected('') : [])))), ' ', dom.submitbutton('Add token'))), tokenElem = dom.div());
+};
+const auditlog = async () => {
+	const max = 1000;
+	const entries = await client.AuditList('', max) || [];
+	dom._kids(page, crumbs(crumblink('Mox Admin', '#'), 'Audit log'), dom.p('Administrative actions, such as configuration changes, password resets and queue operations, through the admin web interface, the admin API and the mox command-line. Entries are never changed or removed. Passwords are replaced with "***".'), dom.div(dom.clickbutton('Export as JSON', attr.title('Download all entries of the audit log as a JSON file.'), async function click(e) {
+		const all = await check(e.target, client.AuditList('', 0)) || [];
+		const blob = new Blob([JSON.stringify(all, undefined, '\t')], { type: 'application/json' });
+		const a = dom.a(attr.href(URL.createObjectURL(blob)), attr.download('mox-audit-log.json'));
+		a.click();
+		URL.revokeObjectURL(a.href);
+	})), dom.br(), entries.length === max ? dom.p('Showing the most recent ' + max + ' entries, export to see all.') : [], dom.table(dom._class('hover'), dom.thead(dom.tr(dom.th('Time'), dom.th('Source'), dom.th('Actor'), dom.th('Remote IP'), dom.th('Action'), dom.th('Parameters'), dom.th('Error'))), dom.tbody(entries.length === 0 ? dom.tr(dom.td(attr.colspan('7'), '(None)')) : [], entries.map(e => dom.tr(dom.td(e.Time.toISOString(), attr.title(e.Time.toString())), dom.td(e.Source), dom.td(e.Actor), dom.td(e.RemoteIP), dom.td(e.Action), dom.td(style({ maxWidth: '40em', wordBreak: 'break-all' }), e.Params), dom.td(e.Error))))));
+};
+const quarantine = async () => {
+	const messages = await client.Quarantined('') || [];
+	const nowSecs = new Date().getTime() / 1000;
+	dom._kids(page, crumbs(crumblink('Mox Admin', '#'), 'Quarantine'), dom.p('Incoming messages held in quarantine instead of being rejected, for the reasons configured in mox.conf. Released messages are delivered to the mailbox they were destined for. Messages are removed automatically when they expire.'), dom.table(dom._class('hover'), dom.thead(dom.tr(dom.th('Received'), dom.th('Account'), dom.th('Reason'), dom.th('Remote IP'), dom.th('From'), dom.th('To'), dom.th('Subject'), dom.th('Size'), dom.th('Expires'), dom.th('Action'))), dom.tbody(messages.length === 0 ? dom.tr(dom.td(attr.colspan('10'), '(None)')) : [], messages.map(qm => dom.tr(dom.td(age(qm.Received, false, nowSecs)), dom.td(adminScope.LoginAddress ? qm.Account : dom.a(qm.Account, attr.href('#accounts/' + qm.Account))), dom.td(qm.Reason), dom.td(qm.RemoteIP), dom.td(qm.MsgFrom || qm.MailFrom, attr.title('SMTP MAIL FROM: ' + (qm.MailFrom || '<>'))), dom.td(qm.RcptTo), dom.td(qm.Subject), dom.td(formatSize(qm.Size)), dom.td(age(qm.Expires, true, nowSecs)), dom.td(dom.clickbutton('Headers', async function click(e) {
+		const headers = await check(e.target, client.QuarantineHeaders(qm.ID));
+		popup(dom.h1('Headers'), dom.pre(dom._class('literal'), style({ maxWidth: '80em', whiteSpace: 'pre-wrap' }), headers));
+	}), ' ', dom.clickbutton('Release', attr.title('Deliver the message to the mailbox it was destined for.'), async function click(e) {
+		await check(e.target, client.QuarantineRelease(qm.ID));
+		window.location.reload(); // todo: reload less
+	}), ' ', dom.clickbutton('Remove', async function click(e) {
+		if (!window.confirm('Are you sure you want to remove this message?')) {
+			return;
+		}
+		await check(e.target, client.QuarantineRemove(qm.ID));
+		window.location.reload(); // todo: reload less
+	})))))));
+};
+const abusereports = async () => {
+	const reports = await client.AbuseReports(1000) || [];
+	const nowSecs = new Date().getTime() / 1000;
+	dom._kids(page, crumbs(crumblink('Mox Admin', '#'), 'Abuse reports'), dom.p('Abuse reports (ARF) about messages sent by us, received at the role addresses postmaster@, abuse@ and security@ of the domains, from senders with a validated From address. Reports are matched with sent messages through the delivery history. Recipients of messages with a spam complaint (feedback type "abuse") are added to the suppression list of the sending account, and complaints count as bounces for the submission guard. The reports are also delivered to the postmaster mailbox. Reports are kept for 90 days, at most the 1000 most recent reports are shown.'), dom.table(dom._class('hover'), dom.thead(dom.tr(dom.th('Time'), dom.th('To'), dom.th('Reporter'), dom.th('Type'), dom.th('Message', attr.title('Subject and Message-ID of the reported message.')), dom.th('Account', attr.title('Account that sent the message, if found in the delivery history.')), dom.th('Recipients'), dom.th('Suppressed', attr.title('Recipients added to the suppression list of the account.')), dom.th('Action'))), dom.tbody(reports.length === 0 ? dom.tr(dom.td(attr.colspan('9'), '(None)')) : [], reports.map(r => dom.tr(r.Handled ? [] : style({ fontWeight: 'bold' }), dom.td(age(r.Time, false, nowSecs)), dom.td(r.Rcpt), dom.td(r.ReporterFrom, r.UserAgent ? attr.title('User agent: ' + r.UserAgent) : []), dom.td(r.FeedbackType, r.Incidents > 1 ? ' (' + r.Incidents + 'x)' : ''), dom.td(r.Subject, r.MessageID ? [dom.br(), dom.a(r.MessageID, attr.href('#messagetrace/' + encodeURIComponent(r.MessageID)), attr.title('Delivery history of message.'))] : []), dom.td(r.Account ? dom.a(r.Account, attr.href('#accounts/' + r.Account)) : '-'), dom.td((r.Recipients || []).join(', ') || r.RcptTo), dom.td((r.Suppressed || []).join(', ')), dom.td(dom.clickbutton(r.Handled ? 'Mark unhandled' : 'Mark handled', async function click(e) {
+		await check(e.target, client.AbuseReportSetHandled(r.ID, !r.Handled));
+		window.location.reload(); // todo: reload less
+	})))))));
+};
+const spamtraps = async () => {
+	const hits = await client.SpamtrapHits(1000) || [];
+	const nowSecs = new Date().getTime() / 1000;
+	dom._kids(page, crumbs(crumblink('Mox Admin', '#'), 'Spamtrap hits'), dom.p('Messages sent to spamtrap addresses, configured per domain. These messages are not delivered. The shared junk filter is trained with them, and for 30 days, messages from the same remote network or validated sender domain are rejected. At most the 1000 most recent hits are shown.'), dom.table(dom._class('hover'), dom.thead(dom.tr(dom.th('Time'), dom.th('Spamtrap'), dom.th('Remote IP'), dom.th('EHLO'), dom.th('Sender domain', attr.title('Organizational domain of the sender, if validated through DMARC or SPF.')), dom.th('From'), dom.th('Subject'), dom.th('Size'))), dom.tbody(hits.length === 0 ? dom.tr(dom.td(attr.colspan('8'), '(None)')) : [], hits.map(h => dom.tr(dom.td(age(h.Time, false, nowSecs)), dom.td(h.Trap), dom.td(h.RemoteIP, attr.title('Network: ' + h.RemoteNetwork)), dom.td(h.EHLO), dom.td(h.Domain), dom.td(h.MsgFrom || h.MailFrom, attr.title('SMTP MAIL FROM: ' + (h.MailFrom || '<>'))), dom.td(h.Subject), dom.td(formatSize(h.Size)))))));
+};
+const lineendings = async () => {
+	const sources = await client.LineEndingSources(1000) || [];
+	const nowSecs = new Date().getTime() / 1000;
+	dom._kids(page, crumbs(crumblink('Mox Admin', '#'), 'Line ending anomalies'), dom.p('Remote IPs that sent messages with bare carriage returns or newlines, or with a dot on a line ending in something other than CRLF, in the SMTP message data. Such messages can be used for SMTP smuggling. Whether messages with anomalies are rejected or only logged is configured with SMTPLineEndings in mox.conf. Use the counts below to see which senders would be affected before rejecting. Sources are removed 30 days after their last message. At most the 1000 most recent sources are shown.'), dom.table(dom._class('hover'), dom.thead(dom.tr(dom.th('Last'), dom.th('First'), dom.th('Remote IP'), dom.th('EHLO', attr.title('Of the most recent message.')), dom.th('MAIL FROM domain', attr.title('Of the most recent message.')), dom.th('Submission', attr.title('Whether the most recent message was submitted by an authenticated account.')), dom.th('Messages'), dom.th('Bare CR'), dom.th('Bare LF'), dom.th('Bad data end', attr.title('Dot on a line with other line endings than CRLF.')), dom.th('Rejected'))), dom.tbody(sources.length === 0 ? dom.tr(dom.td(attr.colspan('11'), '(None)')) : [], sources.map(s => dom.tr(dom.td(age(s.Last, false, nowSecs)), dom.td(age(s.First, false, nowSecs)), dom.td(s.RemoteIP), dom.td(s.EHLO), dom.td(s.MailFromDomain || '<>'), dom.td(s.Submission ? 'Yes' : 'No'), dom.td(style({ textAlign: 'right' }), '' + s.Messages), dom.td(style({ textAlign: 'right' }), '' + s.BareCR), dom.td(style({ textAlign: 'right' }), '' + s.BareLF), dom.td(style({ textAlign: 'right' }), '' + s.BadDataEnd), dom.td(style({ textAlign: 'right' }), '' + s.Rejected))))));
+};
+const autobans = async () => {
+	const bans = await client.AutoBans() || [];
+	const nowSecs = new Date().getTime() / 1000;
+	dom._kids(page, crumbs(crumblink('Mox Admin', '#'), 'Automatic bans'), dom.p('Networks automatically banned after many failed authentication attempts or protocol errors, such as unknown commands, within a time window. New connections and logins from banned networks are refused until the ban ends. Bans are kept in memory only, and are lost on restart. Automatic bans are configured with AutoBan in mox.conf.'), dom.table(dom._class('hover'), dom.thead(dom.tr(dom.th('Network'), dom.th('Reason'), dom.th('Failures'), dom.th('Start'), dom.th('End'), dom.th('Refused', attr.title('Connections and logins refused during the ban.')), dom.th('Action'))), dom.tbody(bans.length === 0 ? dom.tr(dom.td(attr.colspan('7'), '(None)')) : [], bans.map(b => dom.tr(dom.td(b.Network), dom.td(b.Reason), dom.td(style({ textAlign: 'right' }), '' + b.Failures), dom.td(age(b.Start, false, nowSecs)), dom.td(age(b.End, true, nowSecs)), dom.td(style({ textAlign: 'right' }), '' + b.Refused), dom.td(dom.clickbutton('Remove', attr.title('Remove the ban, allowing connections from the network again.'), async function click(e) {
+		await check(e.target, client.AutoBanRemove(b.Network));
+		window.location.reload(); // todo: only reload the list
+	})))))));
 };
 const loglevels = async () => {
 	const loglevels = await client.LogLevels();
@@ -2057,7 +3032,11 @@ const loglevels = async () => {
 	let fieldset;
 	let pkg;
 	let level;
-	dom._kids(page, crumbs(crumblink('Mox Admin', '#'), 'Log levels'), dom.p('Note: changing a log level here only changes it for the current process. When mox restarts, it sets the log levels from the configuration file. Change mox.conf to keep the changes.'), dom.table(dom.thead(dom.tr(dom.th('Package', attr.title('Log levels can be configured per package. E.g. smtpserver, imapserver, dkim, dmarc, tlsrpt, etc.')), dom.th('Level', attr.title('If you set the log level to "trace", imap and smtp protocol transcripts will be logged. Sensitive authentication is replaced with "***" unless the level is >= "traceauth". Data is masked with "..." unless the level is "tracedata".')), dom.th('Action'))), dom.tbody(Object.entries(loglevels).map(t => {
+	dom._kids(page, crumbs(crumblink('Mox Admin', '#'), 'Log levels'), dom.p('Note: changing a log level here only changes it for the current process. When mox restarts, or receives a SIGHUP signal, it sets the log levels from the configuration file. Change mox.conf to keep the changes.'), dom.div(dom.clickbutton('Reset to config file', attr.title('Set the log levels from mox.conf, undoing changes made here.'), async function click(e) {
+		e.preventDefault();
+		await check(e.target, client.LogLevelsReset());
+		window.location.reload(); // todo: reload just the current loglevels
+	})), dom.br(), dom.table(dom.thead(dom.tr(dom.th('Package', attr.title('Log levels can be configured per package. E.g. smtpserver, imapserver, dkim, dmarc, tlsrpt, etc.')), dom.th('Level', attr.title('If you set the log level to "trace", imap and smtp protocol transcripts will be logged. Sensitive authentication is replaced with "***" unless the level is >= "traceauth". Data is masked with "..." unless the level is "tracedata".')), dom.th('Action'))), dom.tbody(Object.entries(loglevels).map(t => {
 		let lvl;
 		return dom.tr(dom.td(t[0] || '(default)'), dom.td(lvl = dom.select(levels.map(l => dom.option(l, t[1] === l ? attr.selected('') : [])))), dom.td(dom.clickbutton('Save', attr.title('Set new log level for package.'), async function click(e) {
 			e.preventDefault();
@@ -2076,6 +3055,80 @@ const loglevels = async () => {
 		window.location.reload(); // todo: reload just the current loglevels
 	}, fieldset = dom.fieldset(dom.label(style({ display: 'inline-block' }), 'Package', dom.br(), pkg = dom.input(attr.required(''))), ' ', dom.label(style({ display: 'inline-block' }), 'Level', dom.br(), level = dom.select(attr.required(''), levels.map(l => dom.option(l, l === 'debug' ? attr.selected('') : [])))), ' ', dom.submitbutton('Add')), dom.br(), dom.p('Suggestions for packages: autotls dkim dmarc dmarcdb dns dnsbl dsn http imapserver iprev junk message metrics mox moxio mtasts mtastsdb publicsuffix queue sendmail serve smtpserver spf store subjectpass tlsrpt tlsrptdb updates')));
 };
+const logs = async () => {
+	const levels = ['', 'error', 'info', 'warn', 'debug', 'trace', 'traceauth', 'tracedata'];
+	let fieldset;
+	let account;
+	let messageID;
+	let cid;
+	let pkg;
+	let level;
+	let text;
+	let max;
+	let results;
+	const render = (entries, n) => {
+		dom._kids(results, entries.length === n ? dom.p('Showing the most recent ' + n + ' matching entries.') : [], dom.table(dom._class('hover'), dom.thead(dom.tr(dom.th('Time'), dom.th('Level'), dom.th('Package'), dom.th('Message'), dom.th('Attributes'))), dom.tbody(entries.length === 0 ? dom.tr(dom.td(attr.colspan('5'), '(None)')) : [], entries.map(e => dom.tr(dom.td(e.Time.toISOString(), attr.title(e.Time.toString())), dom.td(e.Level), dom.td(e.Pkg), dom.td(e.Message), dom.td(style({ maxWidth: '60em', wordBreak: 'break-all' }), (e.Fields || []).map(f => f.Key + '=' + f.Value).join(' ')))))));
+	};
+	dom._kids(page, crumbs(crumblink('Mox Admin', '#'), 'Recent log'), dom.p('Recent log entries, kept in memory by the running mox process, most recent first. Only entries at the configured log levels are kept, see ', dom.a('Log levels', attr.href('#loglevels')), '. The number of entries kept is configured with LogRecent in mox.conf.'), dom.form(async function submit(e) {
+		e.preventDefault();
+		e.stopPropagation();
+		const filter = {
+			Account: account.value,
+			MessageID: messageID.value,
+			Cid: cid.value,
+			Pkg: pkg.value,
+			Level: level.value,
+			Text: text.value,
+			Max: parseInt(max.value) || 0,
+		};
+		const entries = await check(fieldset, client.LogRecent(filter)) || [];
+		render(entries, filter.Max || 1000);
+	}, fieldset = dom.fieldset(dom.label(style({ display: 'inline-block' }), 'Account', dom.br(), account = dom.input()), ' ', dom.label(style({ display: 'inline-block' }), 'Message-ID', dom.br(), messageID = dom.input()), ' ', dom.label(style({ display: 'inline-block' }), 'Connection ID', attr.title('The "cid" attribute in log entries, in hex. Use "Lookup cid" on the main page to find the cid for the ID in a Received header.'), dom.br(), cid = dom.input()), ' ', dom.label(style({ display: 'inline-block' }), 'Package', dom.br(), pkg = dom.input()), ' ', dom.label(style({ display: 'inline-block' }), 'Minimum level', dom.br(), level = dom.select(levels.map(l => dom.option(l || '(any)', attr.value(l))))), ' ', dom.label(style({ display: 'inline-block' }), 'Text', attr.title('Case-insensitive text in the message or attribute values.'), dom.br(), text = dom.input()), ' ', dom.label(style({ display: 'inline-block' }), 'Max', dom.br(), max = dom.input(attr.type('number'), attr.min('1'), attr.value('1000'), style({ width: '6em' }))), ' ', dom.submitbutton('Search'))), dom.br(), results = dom.div());
+	const entries = await client.LogRecent({ Account: '', MessageID: '', Cid: '', Pkg: '', Level: '', Text: '', Max: 1000 }) || [];
+	render(entries, 1000);
+};
+const messagetrace = async (id) => {
+	const events = id ? await client.MessageTrace(id) || [] : [];
+	let idElem;
+	dom._kids(page, crumbs(crumblink('Mox Admin', '#'), 'Message trace'), dom.p('Delivery history of a message: when it was received, rulesets that matched, the junk filter classification, whether it was delivered, rejected or queued, and each delivery attempt from the queue with the response from the remote server. Events are kept for 30 days.'), dom.form(function submit(e) {
+		e.preventDefault();
+		e.stopPropagation();
+		window.location.hash = '#messagetrace/' + encodeURIComponent(idElem.value.trim());
+	}, dom.fieldset(dom.label(style({ display: 'inline-block' }), 'Message-ID or queue message ID', dom.br(), idElem = dom.input(attr.required(''), attr.value(id), style({ width: '40em' }))), ' ', dom.submitbutton('Trace'))), dom.br(), id ? dom.table(dom._class('hover'), dom.thead(dom.tr(dom.th('Time'), dom.th('Event'), dom.th('Queue ID'), dom.th('Account'), dom.th('Recipient'), dom.th('Remote'), dom.th('Result'), dom.th('Detail'), dom.th('Cid', attr.title('Connection ID, as in the "cid" attribute in logging.')))), dom.tbody(events.length === 0 ? dom.tr(dom.td(attr.colspan('9'), 'No events found.')) : [], events.map(e => dom.tr(dom.td(e.Time.toISOString(), attr.title(e.Time.toString())), dom.td(e.Kind), dom.td(e.QueueID ? '' + e.QueueID : ''), dom.td(e.Account), dom.td(e.Recipient), dom.td(e.Remote), dom.td(e.Result), dom.td(style({ maxWidth: '50em', wordBreak: 'break-word' }), e.Detail), dom.td(e.Cid ? e.Cid.toString(16) : ''))))) : []);
+};
+const usage = async () => {
+	const month = new Date().toISOString().substring(0, 7);
+	let fieldset;
+	let start;
+	let end;
+	let account;
+	let domain;
+	let group;
+	let results;
+	let last = [];
+	const csv = (l) => {
+		const fields = ['month', 'account', 'domain', 'messages_received', 'bytes_received', 'messages_sent', 'bytes_sent', 'stored_bytes'];
+		const quote = (s) => /[",\r\n]/.test(s) ? '"' + s.replace(/"/g, '""') + '"' : s;
+		const lines = [fields.join(',')].concat(l.map(u => [quote(u.Month), quote(u.Account), quote(u.Domain), u.MessagesReceived, u.BytesReceived, u.MessagesSent, u.BytesSent, u.StoredBytes].join(',')));
+		return lines.join('\r\n') + '\r\n';
+	};
+	const search = async () => {
+		const l = await check(fieldset, client.UsageList(start.value, end.value, account.value.trim(), domain.value.trim(), group.value)) || [];
+		last = l;
+		dom._kids(results, dom.table(dom._class('hover'), dom.thead(dom.tr(dom.th('Month'), dom.th('Account'), dom.th('Domain'), dom.th('Received', attr.title('Incoming messages delivered to the account, per recipient.')), dom.th('Received size'), dom.th('Sent', attr.title('Outgoing messages delivered to remote mail servers, per recipient.')), dom.th('Sent size'), dom.th('Stored size', attr.title('Highest sampled total size of messages in the account during the month.')))), dom.tbody(l.length === 0 ? dom.tr(dom.td(attr.colspan('8'), '(None)')) : [], l.map(u => dom.tr(dom.td(u.Month), dom.td(u.Account ? dom.a(u.Account, attr.href('#accounts/' + encodeURIComponent(u.Account))) : ''), dom.td(u.Domain), dom.td(style({ textAlign: 'right' }), '' + u.MessagesReceived), dom.td(style({ textAlign: 'right' }), formatSize(u.BytesReceived)), dom.td(style({ textAlign: 'right' }), '' + u.MessagesSent), dom.td(style({ textAlign: 'right' }), formatSize(u.BytesSent)), dom.td(style({ textAlign: 'right' }), formatSize(u.StoredBytes)))))));
+	};
+	dom._kids(page, crumbs(crumblink('Mox Admin', '#'), 'Usage'), dom.p('Usage per calendar month (UTC), per account and domain, for reporting and billing: incoming messages delivered to accounts, outgoing messages delivered to remote mail servers, and the highest total size of stored messages. Also available with "mox usage" and through the admin API.'), dom.form(async function submit(e) {
+		e.preventDefault();
+		e.stopPropagation();
+		await search();
+	}, fieldset = dom.fieldset(dom.label(style({ display: 'inline-block' }), 'Start month', dom.br(), start = dom.input(attr.type('month'), attr.value(month))), ' ', dom.label(style({ display: 'inline-block' }), 'End month', dom.br(), end = dom.input(attr.type('month'), attr.value(month))), ' ', dom.label(style({ display: 'inline-block' }), 'Account', dom.br(), account = dom.input()), ' ', dom.label(style({ display: 'inline-block' }), 'Domain', dom.br(), domain = dom.input()), ' ', dom.label(style({ display: 'inline-block' }), 'Group', dom.br(), group = dom.select(dom.option('Per account and domain', attr.value('')), dom.option('Per account', attr.value('account')), dom.option('Per domain', attr.value('domain')))), ' ', dom.submitbutton('Show'), ' ', dom.clickbutton('Export as CSV', attr.title('Download the usage shown as a CSV file.'), function click() {
+		const blob = new Blob([csv(last)], { type: 'text/csv' });
+		const a = dom.a(attr.href(URL.createObjectURL(blob)), attr.download('mox-usage.csv'));
+		a.click();
+		URL.revokeObjectURL(a.href);
+	}))), dom.br(), results = dom.div());
+	await search();
+};
 const box = (color, ...l) => [
 	dom.div(style({
 		display: 'inline-block',
@@ -2192,12 +3245,90 @@ const RoutesEditor = (kind, transports, routes, save) => {
 	};
 	return render();
 };
+const FooterEditor = (kind, footer, save) => {
+	let fieldset;
+	let text;
+	let html;
+	let skipReplies;
+	// Empty lines within the footer are kept, leading and trailing empty lines are not.
+	const lines = (s) => s.trim() ? s.replace(/^\s*\n|\n\s*$/g, '').split('\n').map(s => s.trimEnd()) : [];
+	return [
+		dom.h2('Footer', attr.title('Footer, e.g. a legal disclaimer, added to the text of messages submitted by ' + kind + '. It is added to text/plain and text/html parts, not to attachments. Signed and encrypted messages are not changed. A footer configured for an account takes precedence over a footer configured for the domain of the message From address.')),
+		dom.form(async function submit(e) {
+			e.preventDefault();
+			e.stopPropagation();
+			const f = { Text: lines(text.value), HTML: lines(html.value), SkipReplies: skipReplies.checked };
+			const nf = (f.Text || []).length === 0 && (f.HTML || []).length === 0 ? null : f;
+			await check(fieldset, save(nf));
+		}, fieldset = dom.fieldset(style({ display: 'flex', gap: '1em' }), dom.label(dom.div('Text', attr.title('Added after an empty line at the end of text/plain parts. If empty, text/plain parts are not changed.')), text = dom.textarea((footer.Text || []).join('\n'), attr.rows('4'), style({ width: '30em' }))), dom.label(dom.div('HTML', attr.title('Inserted before the closing body tag of text/html parts. If empty, the text footer is used, escaped for HTML.')), html = dom.textarea((footer.HTML || []).join('\n'), attr.rows('4'), style({ width: '30em' }))), dom.div(dom.label(skipReplies = dom.input(attr.type('checkbox'), footer.SkipReplies ? attr.checked('') : []), ' Skip replies', attr.title('Do not add the footer to replies, i.e. messages with an In-Reply-To or References header. Earlier messages in the thread typically already have the footer.')), dom.br(), dom.submitbutton('Save', attr.title('Save footer. With empty text and HTML, the footer is removed.'))))),
+	];
+};
+const AddressRewritesEditor = (kind, rules, save) => {
+	const hdr = dom.h2('Address rewrites', attr.title('Rules for rewriting email addresses, e.g. to map a legacy domain onto a new domain during a migration, or to masquerade addresses of an internal domain. Recipient rules are applied to incoming messages and submitted messages, sender rules to the SMTP MAIL FROM and message From addresses of submitted messages. For an address, the rules of its domain and the global rules are evaluated by descending priority, the first match is applied.'));
+	let rewritesElem;
+	const render = () => {
+		let rewritesFieldset;
+		let rewriteRows = [];
+		const add = () => {
+			rules = rewriteRows.map(rr => rr.gather());
+			rules.push({ AddressRegexp: '', Replace: '', Priority: 0, Sender: false, Recipient: true });
+			render();
+		};
+		let elem = dom.form(async function submit(e) {
+			e.stopPropagation();
+			e.preventDefault();
+			await check(rewritesFieldset, save(rewriteRows.map(rr => rr.gather())));
+		}, rewritesFieldset = dom.fieldset(dom.table(dom.thead(dom.tr(dom.th('Address regexp', attr.title('Regular expression matched against the full email address, case-insensitive, e.g. ^(.+)@internal\\.example$.')), dom.th('Replace', attr.title('Replacement address, with $1 for the first submatch, etc., e.g. $1@example.org.')), dom.th('Priority', attr.title('Rules with a higher priority are evaluated first.')), dom.th('Sender', attr.title('Rewrite sender addresses of submitted messages. The rewritten address must be in a configured domain.')), dom.th('Recipient', attr.title('Rewrite recipient addresses of incoming and submitted messages.')), dom.th(dom.clickbutton('Add', function click() { add(); })))), dom.tbody((rules || []).length === 0 ? dom.tr(dom.td(attr.colspan('6'), 'No address rewrites.')) : [], rewriteRows = (rules || []).map((r, index) => {
+			let addressRegexp = dom.input(attr.value(r.AddressRegexp), attr.required(''));
+			let replace = dom.input(attr.value(r.Replace), attr.required(''));
+			let priority = dom.input(attr.type('number'), attr.value('' + r.Priority), style({ width: '5em' }));
+			let sender = dom.input(attr.type('checkbox'), r.Sender ? attr.checked('') : []);
+			let recipient = dom.input(attr.type('checkbox'), r.Recipient ? attr.checked('') : []);
+			const tr = dom.tr(dom.td(addressRegexp), dom.td(replace), dom.td(priority), dom.td(sender), dom.td(recipient), dom.td(dom.clickbutton('Remove', function click() {
+				rewriteRows.splice(index, 1);
+				rules = rewriteRows.map(rr => rr.gather());
+				render();
+			})));
+			return {
+				root: tr,
+				gather: () => {
+					return {
+						AddressRegexp: addressRegexp.value,
+						Replace: replace.value,
+						Priority: parseInt(priority.value) || 0,
+						Sender: sender.checked,
+						Recipient: recipient.checked,
+					};
+				},
+			};
+		}))), dom.div(dom.submitbutton('Save'))));
+		if (!rewritesElem && (rules || []).length === 0) {
+			// Keep it short.
+			elem = dom.div('No ' + kind + ' address rewrites configured. ', dom.clickbutton('Add', function click() { add(); }));
+		}
+		elem = dom.div(hdr, elem);
+		if (rewritesElem) {
+			rewritesElem.replaceWith(elem);
+		}
+		rewritesElem = elem;
+		return elem;
+	};
+	return render();
+};
 const account = async (name) => {
-	const [[config, diskUsage], domains, transports] = await Promise.all([
+	const [[config, diskUsage], domains, transports, sessions, passkeys, deletions, incidents, passwordChangeRequired] = await Promise.all([
 		client.Account(name),
 		client.Domains(),
 		client.Transports(),
+		client.AccountProtocolSessions(name),
+		client.AccountPasskeys(name),
+		client.AccountDeletions(),
+		client.SubmissionIncidents(name, 20),
+		client.AccountPasswordChangeRequired(name),
 	]);
+	const deletion = (deletions || []).find(d => d.Account === name);
+	const nowSecs = new Date().getTime() / 1000;
+	const throttled = (incidents || []).some(si => si.Action === 'throttle' && !si.Cleared && si.Until.getTime() / 1000 > nowSecs);
 	// todo: show suppression list, and buttons to add/remove entries.
 	let form;
 	let fieldset;
@@ -2206,8 +3337,12 @@ const account = async (name) => {
 	let fieldsetSettings;
 	let maxOutgoingMessagesPerDay;
 	let maxFirstTimeRecipientsPerDay;
+	let maxAliases;
 	let quotaMessageSize;
 	let firstTimeSenderDelay;
+	let fieldsetJunkShared;
+	let junkContributeShared;
+	let junkSharedWeight;
 	let formPassword;
 	let fieldsetPassword;
 	let password;
@@ -2269,11 +3404,19 @@ const account = async (name) => {
 	}, fieldset = dom.fieldset(dom.label(style({ display: 'inline-block' }), dom.span('Localpart', attr.title('The localpart is the part before the "@"-sign of an email address. If empty, a catchall address is configured for the domain.')), dom.br(), localpart = dom.input()), '@', dom.label(style({ display: 'inline-block' }), dom.span('Domain'), dom.br(), domain = dom.select((domains || []).map(d => dom.option(domainName(d), domainName(d) === config.Domain ? attr.selected('') : [])))), ' ', dom.submitbutton('Add address'))), dom.br(), dom.h2('Aliases/lists'), dom.table(dom.thead(dom.tr(dom.th('Alias address'), dom.th('Subscription address'), dom.th('Allowed senders', attr.title('Whether only members can send through the alias/list, or anyone.')), dom.th('Send as alias address', attr.title('If enabled, messages can be sent with the alias address in the message "From" header.')), dom.th('Members visible', attr.title('If enabled, members can see the addresses of other members.')))), (config.Aliases || []).length === 0 ? dom.tr(dom.td(attr.colspan('6'), 'None')) : [], (config.Aliases || []).sort((a, b) => a.Alias.LocalpartStr < b.Alias.LocalpartStr ? -1 : (domainName(a.Alias.Domain) < domainName(b.Alias.Domain) ? -1 : 1)).map(a => dom.tr(dom.td(dom.a(prewrap(a.Alias.LocalpartStr, '@', domainName(a.Alias.Domain)), attr.href('#domains/' + domainName(a.Alias.Domain) + '/alias/' + encodeURIComponent(a.Alias.LocalpartStr)))), dom.td(prewrap(a.SubscriptionAddress)), dom.td(a.Alias.PostPublic ? 'Anyone' : 'Members only'), dom.td(a.Alias.AllowMsgFrom ? 'Yes' : 'No'), dom.td(a.Alias.ListMembers ? 'Yes' : 'No'), dom.td(dom.clickbutton('Remove', async function click(e) {
 		await check(e.target, client.AliasAddressesRemove(a.Alias.LocalpartStr, domainName(a.Alias.Domain), [a.SubscriptionAddress]));
 		window.location.reload(); // todo: reload less
-	}))))), dom.br(), dom.h2('Settings'), dom.form(fieldsetSettings = dom.fieldset(dom.label(style({ display: 'block', marginBottom: '.5ex' }), dom.span('Maximum outgoing messages per day', attr.title('Maximum number of outgoing messages for this account in a 24 hour window. This limits the damage to recipients and the reputation of this mail server in case of account compromise. Default 1000. MaxOutgoingMessagesPerDay in configuration file.')), dom.br(), maxOutgoingMessagesPerDay = dom.input(attr.type('number'), attr.required(''), attr.value('' + (config.MaxOutgoingMessagesPerDay || 1000)))), dom.label(style({ display: 'block', marginBottom: '.5ex' }), dom.span('Maximum first-time recipients per day', attr.title('Maximum number of first-time recipients in outgoing messages for this account in a 24 hour window. This limits the damage to recipients and the reputation of this mail server in case of account compromise. Default 200. MaxFirstTimeRecipientsPerDay in configuration file.')), dom.br(), maxFirstTimeRecipientsPerDay = dom.input(attr.type('number'), attr.required(''), attr.value('' + (config.MaxFirstTimeRecipientsPerDay || 200)))), dom.label(style({ display: 'block', marginBottom: '.5ex' }), dom.span('Disk usage quota: Maximum total message size ', attr.title('Default maximum total message size in bytes for the account, overriding any globally configured default maximum size if non-zero. A negative value can be used to have no limit in case there is a limit by default. Attempting to add new messages to an account beyond its maximum total size will result in an error. Useful to prevent a single account from filling storage.')), dom.br(), quotaMessageSize = dom.input(attr.value(formatQuotaSize(config.QuotaMessageSize))), ' Current usage is ', formatQuotaSize(Math.floor(diskUsage / (1024 * 1024)) * 1024 * 1024), '.'), dom.div(style({ display: 'block', marginBottom: '.5ex' }), dom.label(firstTimeSenderDelay = dom.input(attr.type('checkbox'), config.NoFirstTimeSenderDelay ? [] : attr.checked('')), ' ', dom.span('Delay deliveries from first-time senders.', attr.title('To slow down potential spammers, when the message is misclassified as non-junk. Turning off the delay can be useful when the account processes messages automatically and needs fast responses.')))), dom.submitbutton('Save')), async function submit(e) {
+	}))))), dom.br(), adminScope.LoginAddress ? [] : [dom.h2('Settings'), dom.form(fieldsetSettings = dom.fieldset(dom.label(style({ display: 'block', marginBottom: '.5ex' }), dom.span('Maximum outgoing messages per day', attr.title('Maximum number of outgoing messages for this account in a 24 hour window. This limits the damage to recipients and the reputation of this mail server in case of account compromise. Default 1000. MaxOutgoingMessagesPerDay in configuration file.')), dom.br(), maxOutgoingMessagesPerDay = dom.input(attr.type('number'), attr.required(''), attr.value('' + (config.MaxOutgoingMessagesPerDay || 1000)))), dom.label(style({ display: 'block', marginBottom: '.5ex' }), dom.span('Maximum first-time recipients per day', attr.title('Maximum number of first-time recipients in outgoing messages for this account in a 24 hour window. This limits the damage to recipients and the reputation of this mail server in case of account compromise. Default 200. MaxFirstTimeRecipientsPerDay in configuration file.')), dom.br(), maxFirstTimeRecipientsPerDay = dom.input(attr.type('number'), attr.required(''), attr.value('' + (config.MaxFirstTimeRecipientsPerDay || 200)))), dom.label(style({ display: 'block', marginBottom: '.5ex' }), dom.span('Maximum self-service aliases', attr.title('Maximum number of aliases the account can create itself through the account web interface, in domains of its addresses and with its own addresses as members. Default 0, account can not create aliases. MaxAliases in configuration file.')), dom.br(), maxAliases = dom.input(attr.type('number'), attr.min('0'), attr.required(''), attr.value('' + (config.MaxAliases || 0)))), dom.label(style({ display: 'block', marginBottom: '.5ex' }), dom.span('Disk usage quota: Maximum total message size ', attr.title('Default maximum total message size in bytes for the account, overriding any globally configured default maximum size if non-zero. A negative value can be used to have no limit in case there is a limit by default. Attempting to add new messages to an account beyond its maximum total size will result in an error. Useful to prevent a single account from filling storage.')), dom.br(), quotaMessageSize = dom.input(attr.value(formatQuotaSize(config.QuotaMessageSize))), ' Current usage is ', formatQuotaSize(Math.floor(diskUsage / (1024 * 1024)) * 1024 * 1024), '.'), dom.div(style({ display: 'block', marginBottom: '.5ex' }), dom.label(firstTimeSenderDelay = dom.input(attr.type('checkbox'), config.NoFirstTimeSenderDelay ? [] : attr.checked('')), ' ', dom.span('Delay deliveries from first-time senders.', attr.title('To slow down potential spammers, when the message is misclassified as non-junk. Turning off the delay can be useful when the account processes messages automatically and needs fast responses.')))), dom.submitbutton('Save')), async function submit(e) {
 		e.stopPropagation();
 		e.preventDefault();
-		await check(fieldsetSettings, client.AccountSettingsSave(name, parseInt(maxOutgoingMessagesPerDay.value) || 0, parseInt(maxFirstTimeRecipientsPerDay.value) || 0, xparseSize(quotaMessageSize.value), firstTimeSenderDelay.checked));
-	}), dom.br(), dom.h2('Set new password'), formPassword = dom.form(fieldsetPassword = dom.fieldset(dom.label(style({ display: 'inline-block' }), 'New password', dom.br(), password = dom.input(attr.type('password'), attr.autocomplete('new-password'), attr.required(''), function focus() {
+		await check(fieldsetSettings, client.AccountSettingsSave(name, parseInt(maxOutgoingMessagesPerDay.value) || 0, parseInt(maxFirstTimeRecipientsPerDay.value) || 0, parseInt(maxAliases.value) || 0, xparseSize(quotaMessageSize.value), firstTimeSenderDelay.checked));
+		}), dom.br()], adminScope.LoginAddress || !config.JunkFilter ? [] : [
+		dom.h2('Shared junk filter', attr.title('The shared junk filter is trained with messages from all accounts that contribute to it. It helps classify messages for accounts without much training history, such as new accounts.')),
+		dom.form(fieldsetJunkShared = dom.fieldset(dom.div(style({ display: 'block', marginBottom: '.5ex' }), dom.label(junkContributeShared = dom.input(attr.type('checkbox'), config.JunkFilter.ContributeShared ? attr.checked('') : []), ' ', dom.span('Contribute to shared junk filter', attr.title('Also train the shared junk filter with messages of this account that are marked as junk or nonjunk. Only enable for accounts whose junk/nonjunk classifications can be trusted. ContributeShared in configuration file.')))), dom.label(style({ display: 'block', marginBottom: '.5ex' }), dom.span('Weight of shared junk filter', attr.title('Between 0 and 1. If larger than 0, incoming messages are also classified with the shared junk filter, and the spam probabilities are combined with this weight for the shared junk filter. If the junk filter of this account does not recognize any significant words, the probability of the shared junk filter is used as is. E.g. 0.3. SharedWeight in configuration file.')), dom.br(), junkSharedWeight = dom.input(attr.type('number'), attr.min('0'), attr.max('1'), attr.step('0.05'), attr.value('' + (config.JunkFilter.SharedWeight || 0)))), dom.submitbutton('Save')), async function submit(e) {
+			e.stopPropagation();
+			e.preventDefault();
+			await check(fieldsetJunkShared, client.AccountJunkFilterSharedSave(name, junkContributeShared.checked, parseFloat(junkSharedWeight.value) || 0));
+		}),
+		dom.br(),
+	], dom.h2('Set new password'), formPassword = dom.form(fieldsetPassword = dom.fieldset(dom.label(style({ display: 'inline-block' }), 'New password', dom.br(), password = dom.input(attr.type('password'), attr.autocomplete('new-password'), attr.required(''), function focus() {
 		passwordHint.style.display = '';
 	})), ' ', dom.submitbutton('Change password')), passwordHint = dom.div(style({ display: 'none', marginTop: '.5ex' }), dom.clickbutton('Generate random password', function click(e) {
 		e.preventDefault();
@@ -2295,14 +3438,76 @@ const account = async (name) => {
 		await check(fieldsetPassword, client.SetPassword(name, password.value));
 		window.alert('Password has been changed.');
 		formPassword.reset();
-	}), dom.br(), RoutesEditor('account-specific', transports, config.Routes || [], async (routes) => await client.AccountRoutesSave(name, routes)), dom.br(), dom.h2('Danger'), dom.clickbutton('Remove account', async function click(e) {
+	}), dom.p(passwordChangeRequired ? box(yellow, 'The user must change the password. Until then, the password is only accepted for logging in to the account web interface.') : [], dom.clickbutton(passwordChangeRequired ? 'No longer require password change' : 'Require password change', attr.title('Require the user to change the password, e.g. after it may have been compromised. Until changed, the password is only accepted for logging in to the account web interface, not for webmail, IMAP and SMTP submission. Web sessions are ended. App passwords keep working.'), async function click(e) {
+		await check(e.target, client.AccountPasswordChangeRequire(name, !passwordChangeRequired));
+		window.location.reload(); // todo: only update the button
+	})), dom.br(), adminScope.LoginAddress ? [] : [RoutesEditor('account-specific', transports, config.Routes || [], async (routes) => await client.AccountRoutesSave(name, routes)), dom.br()], FooterEditor('this account', config.Footer || { Text: [], HTML: [], SkipReplies: false }, async (f) => await client.AccountFooterSave(name, f)), dom.br(), dom.h2('Sessions'), dom.p('Active and recent IMAP and SMTP submission sessions. Closed sessions can log in again, unless the password is changed.'), dom.table(dom._class('hover'), dom.thead(dom.tr(dom.th('Protocol'), dom.th('Login address'), dom.th('Remote IP'), dom.th('Client'), dom.th('Started'), dom.th('Last activity'), dom.th('Status'), dom.th('Action'))), dom.tbody((sessions || []).length === 0 ? dom.tr(dom.td(attr.colspan('8'), '(None)')) : [], (sessions || []).map(s => dom.tr(dom.td(s.Protocol), dom.td(s.LoginAddress), dom.td(s.RemoteIP), dom.td(s.ClientID), dom.td(age(s.Started, false, nowSecs)), dom.td(age(s.LastActivity, false, nowSecs)), dom.td(s.Active ? 'Active' : (s.Closed ? 'Closed' : 'Ended')), dom.td(!s.Active ? [] : dom.clickbutton('Close', async function click(e) {
+		await check(e.target, client.AccountProtocolSessionClose(name, s.ID));
+		window.location.reload(); // todo: reload less
+	})))))), dom.div(style({ marginTop: '1ex' }), dom.clickbutton('Close all active sessions', async function click(e) {
+		if (!window.confirm('Are you sure you want to close all active sessions of this account?')) {
+			return;
+		}
+		await check(e.target, client.AccountProtocolSessionClose(name, 0));
+		window.location.reload(); // todo: reload less
+	})), dom.br(), dom.h2('Passkeys'), dom.p('Passkeys registered by the user for logging in to the web interfaces without password.'), dom.table(dom._class('hover'), dom.thead(dom.tr(dom.th('Label'), dom.th('Login address'), dom.th('Created'), dom.th('Last used'))), dom.tbody((passkeys || []).length === 0 ? dom.tr(dom.td(attr.colspan('4'), '(None)')) : [], (passkeys || []).map(pk => dom.tr(dom.td(pk.Label), dom.td(pk.LoginAddress), dom.td(age(pk.Created, false, nowSecs)), dom.td(pk.LastUsed.getTime() > 0 ? age(pk.LastUsed, false, nowSecs) : 'Never'))))), (passkeys || []).length === 0 ? [] : dom.div(style({ marginTop: '1ex' }), dom.clickbutton('Remove all passkeys', attr.title('E.g. when the user lost a device with a passkey.'), async function click(e) {
+		if (!window.confirm('Are you sure you want to remove all passkeys of this account?')) {
+			return;
+		}
+		await check(e.target, client.AccountPasskeysReset(name));
+		window.location.reload(); // todo: reload less
+	})), dom.br(), (incidents || []).length === 0 ? [] : [
+		dom.h2('Submission incidents'),
+		dom.p('Anomalies detected in messages submitted by the account, e.g. a spike in recipients, a high bounce rate or a submission from a new network, possibly indicating a compromised account.'),
+		throttled ? dom.p(box(yellow, 'Submissions by this account are currently throttled.')) : [],
+		dom.table(dom._class('hover'), dom.thead(dom.tr(dom.th('Time'), dom.th('Source'), dom.th('Remote IP'), dom.th('Anomalies'), dom.th('Action'), dom.th('Until'))), dom.tbody((incidents || []).map(si => dom.tr(dom.td(age(si.Time, false, nowSecs)), dom.td(si.Source), dom.td(si.RemoteIP), dom.td((si.Anomalies || []).map(s => dom.div(s))), dom.td(si.Action), dom.td(si.Action !== 'throttle' ? '' : (si.Cleared ? 'Cleared' : si.Until.toLocaleString())))))),
+		throttled ? dom.div(style({ marginTop: '1ex' }), dom.clickbutton('Clear throttle', attr.title('Accept submissions by this account again, e.g. after the account password has been changed. Frozen accounts must be enabled again by allowing logins and removing the queue hold rule for the account.'), async function click(e) {
+			await check(e.target, client.SubmissionThrottleClear(name));
+			window.location.reload(); // todo: reload less
+		})) : [],
+		dom.br(),
+	], dom.h2('Danger'), deletion ? dom.p(box(yellow, 'Deletion requested at ' + deletion.Requested.toLocaleString() + ' by ' + deletion.RequestedBy + ', logins are disabled. The account is purged after ' + deletion.PurgeAfter.toLocaleString() + '.')) : [], deletion ? [
+		dom.clickbutton('Cancel deletion', async function click(e) {
+			await check(e.target, client.AccountDeletionCancel(name));
+			window.location.reload(); // todo: reload less
+		}),
+		' ',
+		dom.clickbutton('Purge now', attr.title('Remove the account from the configuration, remove its data directory, remove its messages and webhooks from the queue and redact references to the account from the audit log.'), async function click(e) {
+			if (!window.confirm('Are you sure you want to purge this account and all its data now? This cannot be undone.')) {
+				return;
+			}
+			await check(e.target, client.AccountDeletionPurge(name));
+			window.location.hash = '#accounts';
+		}),
+	] : dom.clickbutton('Request deletion', attr.title('Disable logins to the account and close its IMAP/SMTP connections, and purge the account and all its data after a grace period. During the grace period, incoming messages are still accepted, the deletion can be canceled and the data can still be downloaded.'), async function click(e) {
+		const s = window.prompt('Grace period before the account is purged, e.g. 30d or 1w. If 0, the account is purged the next time pending deletions are processed.', '30d');
+		if (s === null) {
+			return;
+		}
+		let grace;
+		try {
+			grace = parseDuration(s);
+		}
+		catch (err) {
+			window.alert('Error: ' + errmsg(err));
+			return;
+		}
+		await check(e.target, client.AccountDeletionRequest(name, grace));
+		window.location.reload(); // todo: reload less
+	}), ' ', dom.clickbutton('Remove account', attr.title('Remove the account from the configuration immediately. The data directory of the account is kept.'), async function click(e) {
 		e.preventDefault();
 		if (!window.confirm('Are you sure you want to remove this account?')) {
 			return;
 		}
 		await check(e.target, client.AccountRemove(name));
 		window.location.hash = '#accounts';
-	}));
+	}), ' ', dom.clickbutton('Reset two-factor authentication', attr.title('Disable two-factor authentication for web logins, e.g. when the user lost access to the authenticator app and recovery codes. The user can log in with just the password and set up two-factor authentication again.'), async function click(e) {
+		if (!window.confirm('Are you sure you want to reset two-factor authentication for this account?')) {
+			return;
+		}
+		await check(e.target, client.AccountTOTPReset(name));
+		window.alert('Two-factor authentication reset.');
+	}), dom.br(), dom.br(), dom.form(attr.target('_blank'), attr.method('POST'), attr.action('takeout'), dom.input(attr.type('hidden'), attr.name('csrf'), attr.value(localStorageGet('webadmincsrftoken') || '')), dom.input(attr.type('hidden'), attr.name('account'), attr.value(name)), dom.submitbutton('Download takeout', attr.title('Download all data of the account in a single archive: account configuration, settings, filter rules, contacts, calendar events and all mailboxes with messages in maildir format.'))));
 };
 const second = 1000 * 1000 * 1000;
 const minute = 60 * second;
@@ -2360,7 +3565,7 @@ const formatDuration = (v, goDuration) => {
 const domain = async (d) => {
 	const end = new Date();
 	const start = new Date(new Date().getTime() - 30 * 24 * 3600 * 1000);
-	const [dmarcSummaries, tlsrptSummaries, [localpartAccounts, localpartAliases], dnsdomain, clientConfigs, accounts, domainConfig, transports] = await Promise.all([
+	const [dmarcSummaries, tlsrptSummaries, [localpartAccounts, localpartAliases], dnsdomain, clientConfigs, accounts, domainConfig, transports, mtastsPromotion, setups] = await Promise.all([
 		client.DMARCSummaries(start, end, d),
 		client.TLSRPTSummaries(start, end, d),
 		client.DomainLocalparts(d),
@@ -2369,6 +3574,8 @@ const domain = async (d) => {
 		client.Accounts(),
 		client.DomainConfig(d),
 		client.Transports(),
+		client.DomainMTASTSPromotion(d),
+		client.DomainSetups(),
 	]);
 	let addrForm;
 	let addrFieldset;
@@ -2379,11 +3586,19 @@ const domain = async (d) => {
 	let aliasAddresses;
 	let descrFieldset;
 	let descrText;
+	let domainAdminsFieldset;
+	let domainAdmins;
 	let clientSettingsDomainFieldset;
 	let clientSettingsDomain;
 	let localpartFieldset;
 	let localpartCatchallSeparator;
 	let localpartCaseSensitive;
+	let senderListsFieldset;
+	let senderAllow;
+	let senderReject;
+	let senderJunk;
+	let spamtrapsFieldset;
+	let spamtrapLocalparts;
 	let dmarcFieldset;
 	let dmarcLocalpart;
 	let dmarcDomain;
@@ -2399,6 +3614,7 @@ const domain = async (d) => {
 	let mtastsMode;
 	let mtastsMaxAge;
 	let mtastsMX;
+	let mtastsEnforceAfter;
 	const popupDKIMHeaders = (sel, span) => {
 		const l = sel.HeadersEffective || [];
 		let headers;
@@ -2439,7 +3655,7 @@ const domain = async (d) => {
 			window.location.reload(); // todo: reload only dkim section
 		}, fieldset = dom.fieldset(dom.div(style({ display: 'flex', gap: '1em' }), dom.div(dom.label(style({ display: 'block', marginBottom: '1ex' }), 'Selector', attr.title('Used in the DKIM-Signature header, and used to form a DNS record under ._domainkey.<domain>.'), dom.div(selector = dom.input(attr.required(''), attr.value(defaultSelector())))), dom.label(style({ display: 'block', marginBottom: '1ex' }), 'Algorithm', attr.title('For signing messages. RSA is common at the time of writing, not all mail servers recognize ed25519 signature.'), dom.div(algorithm = dom.select(dom.option('rsa'), dom.option('ed25519')))), dom.label(style({ display: 'block', marginBottom: '1ex' }), 'Hash', attr.title("Used in signing messages. Don't use sha1 unless you understand the consequences."), dom.div(hash = dom.select(dom.option('sha256')))), dom.label(style({ display: 'block', marginBottom: '1ex' }), 'Canonicalization - header', attr.title('Canonicalization processes the message headers before signing. Relaxed allows more whitespace changes, making it more likely for DKIM signatures to validate after transit through servers that make whitespace modifications. Simple is more strict.'), dom.div(canonHeader = dom.select(dom.option('relaxed'), dom.option('simple')))), dom.label(style({ display: 'block', marginBottom: '1ex' }), 'Canonicalization - body', attr.title('Like canonicalization for headers, but for the bodies.'), dom.div(canonBody = dom.select(dom.option('relaxed'), dom.option('simple')))), dom.label(style({ display: 'block', marginBottom: '1ex' }), 'Signature lifetime', attr.title('How long a signature remains valid. Should be as long as a message may take to be delivered. The signature must be valid at the time a message is being delivered to the final destination.'), dom.div(lifetime = dom.input(attr.value('3d'), attr.required('')))), dom.label(style({ display: 'block', marginBottom: '1ex' }), 'Seal headers', attr.title("DKIM-signatures cover headers. If headers are not sealed, additional message headers can be added with the same key without invalidating the signature. This may confuse software about which headers are trustworthy. Sealing is the safer option."), dom.div(seal = dom.input(attr.type('checkbox'), attr.checked(''))))), dom.div(dom.label(style({ display: 'block', marginBottom: '1ex' }), 'Headers (optional)', attr.title('Headers to sign. If left empty, a set of standard headers are signed. The (standard set of) headers are most easily edited after creating the selector/key.'), dom.div(headers = dom.textarea(attr.rows('15')))))), dom.div(dom.submitbutton('Add')))));
 	};
-	dom._kids(page, crumbs(crumblink('Mox Admin', '#'), 'Domain ' + domainString(dnsdomain)), dom.ul(dom.li(dom.a('Required DNS records', attr.href('#domains/' + d + '/dnsrecords'))), dom.li(dom.a('Check current actual DNS records and domain configuration', attr.href('#domains/' + d + '/dnscheck')))), dom.br(), dom.h2('Client configuration'), dom.p('If autoconfig/autodiscover does not work with an email client, use the settings below for this domain. Authenticate with email address and password. ', dom.span('Explicitly configure', attr.title('To prevent authentication mechanism downgrade attempts that may result in clients sending plain text passwords to a MitM.')), ' the first supported authentication mechanism: SCRAM-SHA-256-PLUS, SCRAM-SHA-1-PLUS, SCRAM-SHA-256, SCRAM-SHA-1, CRAM-MD5.'), dom.table(dom.thead(dom.tr(dom.th('Protocol'), dom.th('Host'), dom.th('Port'), dom.th('Listener'), dom.th('Note'))), dom.tbody((clientConfigs.Entries || []).map(e => dom.tr(dom.td(e.Protocol), dom.td(domainString(e.Host)), dom.td('' + e.Port), dom.td('' + e.Listener), dom.td('' + e.Note))))), dom.br(), dom.h2('DMARC aggregate reports summary'), renderDMARCSummaries(dmarcSummaries || []), dom.br(), dom.h2('TLS reports summary'), renderTLSRPTSummaries(tlsrptSummaries || []), dom.br(), dom.h2('Addresses'), dom.table(dom.thead(dom.tr(dom.th('Address'), dom.th('Account'), dom.th('Action'))), dom.tbody(Object.entries(localpartAccounts).map(t => dom.tr(dom.td(prewrap(t[0]) || '(catchall)'), dom.td(dom.a(t[1], attr.href('#accounts/' + t[1]))), dom.td(dom.clickbutton('Remove', async function click(e) {
+	dom._kids(page, crumbs(crumblink('Mox Admin', '#'), 'Domain ' + domainString(dnsdomain)), (setups || []).some(s => s.Domain === domainName(dnsdomain)) ? box(yellow, 'Setup of this domain has not been completed, outgoing messages are not yet DKIM-signed. ', dom.a('Continue setup', attr.href('#domains/' + d + '/setup'))) : [], dom.ul(dom.li(dom.a('Required DNS records', attr.href('#domains/' + d + '/dnsrecords'))), dom.li(dom.a('Check current actual DNS records and domain configuration', attr.href('#domains/' + d + '/dnscheck')))), dom.br(), dom.h2('Client configuration'), dom.p('If autoconfig/autodiscover does not work with an email client, use the settings below for this domain. Authenticate with email address and password. ', dom.span('Explicitly configure', attr.title('To prevent authentication mechanism downgrade attempts that may result in clients sending plain text passwords to a MitM.')), ' the first supported authentication mechanism: SCRAM-SHA-512-PLUS, SCRAM-SHA-256-PLUS, SCRAM-SHA-1-PLUS, SCRAM-SHA-512, SCRAM-SHA-256, SCRAM-SHA-1, CRAM-MD5.'), dom.table(dom.thead(dom.tr(dom.th('Protocol'), dom.th('Host'), dom.th('Port'), dom.th('Listener'), dom.th('Note'))), dom.tbody((clientConfigs.Entries || []).map(e => dom.tr(dom.td(e.Protocol), dom.td(domainString(e.Host)), dom.td('' + e.Port), dom.td('' + e.Listener), dom.td('' + e.Note))))), dom.br(), dom.h2('DMARC aggregate reports summary'), renderDMARCSummaries(dmarcSummaries || []), dom.br(), dom.h2('TLS reports summary'), renderTLSRPTSummaries(tlsrptSummaries || []), dom.br(), dom.h2('Addresses'), dom.table(dom.thead(dom.tr(dom.th('Address'), dom.th('Account'), dom.th('Action'))), dom.tbody(Object.entries(localpartAccounts).map(t => dom.tr(dom.td(prewrap(t[0]) || '(catchall)'), dom.td(dom.a(t[1], attr.href('#accounts/' + t[1]))), dom.td(dom.clickbutton('Remove', async function click(e) {
 		e.preventDefault();
 		if (!window.confirm('Are you sure you want to remove this address?')) {
 			return;
@@ -2452,8 +3668,8 @@ const domain = async (d) => {
 		await check(addrFieldset, client.AddressAdd(addrLocalpart.value + '@' + d, addrAccount.value));
 		addrForm.reset();
 		window.location.reload(); // todo: only reload the addresses
-	}, addrFieldset = dom.fieldset(dom.label(style({ display: 'inline-block' }), dom.span('Localpart', attr.title('The localpart is the part before the "@"-sign of an address. An empty localpart is the catchall destination/address for the domain.')), dom.br(), addrLocalpart = dom.input()), '@', domainName(dnsdomain), ' ', dom.label(style({ display: 'inline-block' }), dom.span('Account', attr.title('Account to assign the address to.')), dom.br(), addrAccount = dom.select(attr.required(''), (accounts || []).map(a => dom.option(a)))), ' ', dom.submitbutton('Add address', attr.title('Address will be added and the config reloaded.')))), dom.br(), dom.h2('Aliases/lists'), dom.table(dom.thead(dom.tr(dom.th('Address'), dom.th('Allowed senders', attr.title('Whether only members can send through the alias/list, or anyone.')), dom.th('Send as alias address', attr.title('If enabled, messages can be sent with the alias address in the message "From" header.')), dom.th('Members visible', attr.title('If enabled, members can see the addresses of other members.')))), Object.values(localpartAliases).length === 0 ? dom.tr(dom.td(attr.colspan('4'), 'None')) : [], Object.values(localpartAliases).sort((a, b) => a.LocalpartStr < b.LocalpartStr ? -1 : 1).map(a => {
-		return dom.tr(dom.td(dom.a(prewrap(a.LocalpartStr), attr.href('#domains/' + d + '/alias/' + encodeURIComponent(a.LocalpartStr)))), dom.td(a.PostPublic ? 'Anyone' : 'Members only'), dom.td(a.AllowMsgFrom ? 'Yes' : 'No'), dom.td(a.ListMembers ? 'Yes' : 'No'));
+	}, addrFieldset = dom.fieldset(dom.label(style({ display: 'inline-block' }), dom.span('Localpart', attr.title('The localpart is the part before the "@"-sign of an address. An empty localpart is the catchall destination/address for the domain.')), dom.br(), addrLocalpart = dom.input()), '@', domainName(dnsdomain), ' ', dom.label(style({ display: 'inline-block' }), dom.span('Account', attr.title('Account to assign the address to.')), dom.br(), addrAccount = dom.select(attr.required(''), (accounts || []).map(a => dom.option(a)))), ' ', dom.submitbutton('Add address', attr.title('Address will be added and the config reloaded.')))), dom.br(), dom.h2('Aliases/lists'), dom.table(dom.thead(dom.tr(dom.th('Address'), dom.th('Allowed senders', attr.title('Whether only members can send through the alias/list, or anyone.')), dom.th('Send as alias address', attr.title('If enabled, messages can be sent with the alias address in the message "From" header.')), dom.th('Members visible', attr.title('If enabled, members can see the addresses of other members.')), dom.th('Owner', attr.title('Account that created the alias through the account web interface, if any.')))), Object.values(localpartAliases).length === 0 ? dom.tr(dom.td(attr.colspan('5'), 'None')) : [], Object.values(localpartAliases).sort((a, b) => a.LocalpartStr < b.LocalpartStr ? -1 : 1).map(a => {
+		return dom.tr(dom.td(dom.a(prewrap(a.LocalpartStr), attr.href('#domains/' + d + '/alias/' + encodeURIComponent(a.LocalpartStr)))), dom.td(a.PostPublic ? 'Anyone' : 'Members only'), dom.td(a.AllowMsgFrom ? 'Yes' : 'No'), dom.td(a.ListMembers ? 'Yes' : 'No'), dom.td(a.Owner ? dom.a(a.Owner, attr.href('#accounts/' + a.Owner)) : ''));
 	})), dom.br(), dom.h2('Add alias'), dom.form(async function submit(e) {
 		e.preventDefault();
 		e.stopPropagation();
@@ -2462,13 +3678,14 @@ const domain = async (d) => {
 			PostPublic: false,
 			ListMembers: false,
 			AllowMsgFrom: false,
+			Owner: '',
 			// Ignored:
 			LocalpartStr: '',
 			Domain: dnsdomain,
 		};
 		await check(aliasFieldset, client.AliasAdd(aliasLocalpart.value, d, alias));
 		window.location.hash = '#domains/' + d + '/alias/' + encodeURIComponent(aliasLocalpart.value);
-	}, aliasFieldset = dom.fieldset(style({ display: 'flex', alignItems: 'flex-start', gap: '1em' }), dom.label(dom.div('Localpart', attr.title('The localpart is the part before the "@"-sign of an address.')), aliasLocalpart = dom.input(attr.required('')), '@', domainName(dnsdomain), ' '), dom.label(dom.div('Addresses', attr.title('One members address per line, full address of form localpart@domain. At least one address required.')), aliasAddresses = dom.textarea(attr.required(''), attr.rows('1'), function focus() { aliasAddresses.setAttribute('rows', '5'); })), dom.div(dom.div('\u00a0'), dom.submitbutton('Add alias', attr.title('Alias will be added and the config reloaded.'))))), dom.br(), RoutesEditor('domain-specific', transports, domainConfig.Routes || [], async (routes) => await client.DomainRoutesSave(d, routes)), dom.br(), dom.h2('Settings'), dom.form(async function submit(e) {
+	}, aliasFieldset = dom.fieldset(style({ display: 'flex', alignItems: 'flex-start', gap: '1em' }), dom.label(dom.div('Localpart', attr.title('The localpart is the part before the "@"-sign of an address.')), aliasLocalpart = dom.input(attr.required('')), '@', domainName(dnsdomain), ' '), dom.label(dom.div('Addresses', attr.title('One members address per line, full address of form localpart@domain. At least one address required.')), aliasAddresses = dom.textarea(attr.required(''), attr.rows('1'), function focus() { aliasAddresses.setAttribute('rows', '5'); })), dom.div(dom.div('\u00a0'), dom.submitbutton('Add alias', attr.title('Alias will be added and the config reloaded.'))))), dom.br(), adminScope.LoginAddress ? [] : [RoutesEditor('domain-specific', transports, domainConfig.Routes || [], async (routes) => await client.DomainRoutesSave(d, routes)), dom.br(), AddressRewritesEditor('domain-specific', domainConfig.AddressRewrites || [], async (rules) => await client.DomainAddressRewritesSave(d, rules)), dom.br()], dom.h2('Settings'), dom.form(async function submit(e) {
 		e.preventDefault();
 		e.stopPropagation();
 		await check(descrFieldset, client.DomainDescriptionSave(d, descrText.value));
@@ -2480,7 +3697,17 @@ const domain = async (d) => {
 		e.preventDefault();
 		e.stopPropagation();
 		await check(localpartFieldset, client.DomainLocalpartConfigSave(d, localpartCatchallSeparator.value, localpartCaseSensitive.checked));
-	}, localpartFieldset = dom.fieldset(style({ display: 'flex', gap: '1em' }), dom.label(attr.title('If set, upper/lower case is relevant for email delivery.'), dom.div('Localpart case sensitive'), localpartCaseSensitive = dom.input(attr.type('checkbox'), domainConfig.LocalpartCaseSensitive ? attr.checked('') : [])), dom.label(attr.title('If not empty, only the string before the separator is used to for email delivery decisions. For example, if set to \"+\", you+anything@example.com will be delivered to you@example.com.'), dom.div('Localpart catchall separator'), localpartCatchallSeparator = dom.input(attr.value(domainConfig.LocalpartCatchallSeparator))), dom.div(dom.span('\u00a0'), dom.div(dom.submitbutton('Save'))))), dom.br(), dom.h2('DMARC reporting address'), dom.form(style({ marginTop: '1ex' }), async function submit(e) {
+	}, localpartFieldset = dom.fieldset(style({ display: 'flex', gap: '1em' }), dom.label(attr.title('If set, upper/lower case is relevant for email delivery.'), dom.div('Localpart case sensitive'), localpartCaseSensitive = dom.input(attr.type('checkbox'), domainConfig.LocalpartCaseSensitive ? attr.checked('') : [])), dom.label(attr.title('If not empty, only the string before the separator is used to for email delivery decisions. For example, if set to \"+\", you+anything@example.com will be delivered to you@example.com.'), dom.div('Localpart catchall separator'), localpartCatchallSeparator = dom.input(attr.value(domainConfig.LocalpartCatchallSeparator))), dom.div(dom.span('\u00a0'), dom.div(dom.submitbutton('Save'))))), dom.br(), dom.h2('Sender lists', attr.title('Senders of incoming messages to addresses in this domain that are allowed, rejected or delivered to the Junk mailbox. Senders are email addresses, or domains as "@domain", also matching subdomains. Reject and junk entries are matched against the message From address and the SMTP MAIL FROM address. Allow entries skip junk filtering and DNS block list checks, but only for messages with a DMARC pass for the message From address, or an SPF pass for the MAIL FROM address. Entries in the sender lists of accounts take precedence.')), dom.form(async function submit(e) {
+		e.preventDefault();
+		e.stopPropagation();
+		const lines = (s) => s.split('\n').map(s => s.trim()).filter(s => s);
+		await check(senderListsFieldset, client.DomainSenderListsSave(d, lines(senderAllow.value), lines(senderReject.value), lines(senderJunk.value)));
+	}, senderListsFieldset = dom.fieldset(style({ display: 'flex', gap: '1em' }), dom.label(dom.div('Allow, one per line'), senderAllow = dom.textarea((domainConfig.SenderAllow || []).join('\n'), attr.rows('3'), style({ width: '20em' }))), dom.label(dom.div('Reject, one per line'), senderReject = dom.textarea((domainConfig.SenderReject || []).join('\n'), attr.rows('3'), style({ width: '20em' }))), dom.label(dom.div('Junk, one per line'), senderJunk = dom.textarea((domainConfig.SenderJunk || []).join('\n'), attr.rows('3'), style({ width: '20em' }))), dom.div(dom.span('\u00a0'), dom.div(dom.submitbutton('Save'))))), dom.br(), dom.h2('Spamtraps', attr.title('Addresses in this domain that are not used for real mail, e.g. only published where address harvesters find them. Messages to spamtraps are accepted but never delivered. They train the shared junk filter as junk, and cause messages from the same remote network or validated sender domain to be rejected for 30 days. Spamtraps cannot be existing addresses or aliases.')), dom.form(async function submit(e) {
+		e.preventDefault();
+		e.stopPropagation();
+		const lines = (s) => s.split('\n').map(s => s.trim()).filter(s => s);
+		await check(spamtrapsFieldset, client.DomainSpamtrapsSave(d, lines(spamtrapLocalparts.value)));
+	}, spamtrapsFieldset = dom.fieldset(style({ display: 'flex', gap: '1em' }), dom.label(dom.div('Localparts, one per line'), spamtrapLocalparts = dom.textarea((domainConfig.Spamtraps || []).join('\n'), attr.rows('3'), style({ width: '20em' }))), dom.div(dom.span('\u00a0'), dom.div(dom.submitbutton('Save'))))), dom.p(dom.a('Spamtrap hits', attr.href('#spamtraps'))), dom.br(), FooterEditor('accounts with a message From address in this domain', domainConfig.Footer || { Text: [], HTML: [], SkipReplies: false }, async (f) => await client.DomainFooterSave(d, f)), dom.br(), dom.h2('DMARC reporting address'), dom.form(style({ marginTop: '1ex' }), async function submit(e) {
 		e.preventDefault();
 		e.stopPropagation();
 		if (!dmarcLocalpart.value) {
@@ -2524,10 +3751,12 @@ const domain = async (d) => {
 		let mx = [];
 		let mode = api.Mode.ModeNone;
 		let maxAge = 0;
+		let enforceAfter = 0;
 		if (!mtastsPolicyID.value) {
 			mtastsMode.value = '';
 			mtastsMaxAge.value = '';
 			mtastsMX.value = '';
+			mtastsEnforceAfter.value = '';
 			if (domainConfig.MTASTS?.PolicyID && !window.confirm('Are you sure you want to remove the MTA-STS policy? Only remove policies after having served a policy with mode "none" for a long enough period, so all previously served and remotely cached policies have expired past the then-configured DNS TTL plus policy max-age period, and seen the policy with mode "none".')) {
 				return;
 			}
@@ -2539,34 +3768,33 @@ const domain = async (d) => {
 			mode = mtastsMode.value;
 			maxAge = parseDuration(mtastsMaxAge.value);
 			mx = mtastsMX.value ? mtastsMX.value.split('\n') : [];
-			if (domainConfig.MTASTS?.PolicyID === mtastsPolicyID.value && !window.confirm('Are you sure you want to save the policy without updating the policy ID? Remote servers may hold on to the old cached policies. Policy IDs should be changed when the policy is changed. Remember to first update the policy here, then publish the new policy ID in DNS.')) {
-				return;
-			}
+			enforceAfter = mtastsEnforceAfter.value ? parseDuration(mtastsEnforceAfter.value) : 0;
 		}
-		await check(mtastsFieldset, client.DomainMTASTSSave(d, mtastsPolicyID.value, mode, maxAge, mx));
-		if (domainConfig.MTASTS?.PolicyID === mtastsPolicyID.value) {
-			return;
-		}
-		if (domainConfig.MTASTS?.PolicyID && !mtastsPolicyID.value) {
+		const policyID = await check(mtastsFieldset, client.DomainMTASTSSave(d, mtastsPolicyID.value, mode, maxAge, mx, enforceAfter));
+		if (domainConfig.MTASTS?.PolicyID && !policyID) {
 			window.alert("Don't forget to remove the MTA-STS DNS record.");
 			domainConfig.MTASTS = null;
 		}
-		else if (mtastsPolicyID.value) {
-			if (mtastsPolicyID.value !== domainConfig.MTASTS?.PolicyID) {
-				window.alert("Don't forget to update the MTA-STS DNS record with the new policy ID, see suggested DNS records.");
+		else if (policyID) {
+			if (policyID !== domainConfig.MTASTS?.PolicyID) {
+				mtastsPolicyID.value = policyID;
+				window.alert("Don't forget to update the MTA-STS DNS record with the new policy ID " + policyID + ", see suggested DNS records.");
 			}
 			domainConfig.MTASTS = {
-				PolicyID: mtastsPolicyID.value,
+				PolicyID: policyID,
 				Mode: mode,
 				MaxAge: maxAge,
 				MX: mx,
+				EnforceAfter: enforceAfter,
 			};
 		}
 	}, mtastsFieldset = dom.fieldset(style({ display: 'flex', gap: '1em' }), dom.label(attr.title('Policies are versioned. The version must be specified in the DNS record. If you change a policy, first change it here to update the served policy, then update the DNS record with the updated policy ID.'), dom.div('Policy ID ', dom.a('generate', attr.href(''), attr.title('Generate new policy ID based on current time.'), function click(e) {
 		e.preventDefault();
 		// 20060102T150405
 		mtastsPolicyID.value = new Date().toISOString().replace(/-/g, '').replace(/:/g, '').split('.')[0];
-	})), mtastsPolicyID = dom.input(attr.value(domainConfig.MTASTS?.PolicyID || ''))), dom.label(attr.title("If set to \"enforce\", a remote SMTP server will not deliver email to us if it cannot make a WebPKI-verified SMTP STARTTLS connection. In mode \"testing\", deliveries can be done without verified TLS, but errors will be reported through TLS reporting. In mode \"none\", verified TLS is not required, used for phasing out an MTA-STS policy."), dom.div('Mode'), mtastsMode = dom.select(dom.option(''), Object.values(api.Mode).map(s => dom.option(s, domainConfig.MTASTS?.Mode === s ? attr.selected('') : [])))), dom.label(attr.title('How long a remote mail server is allowed to cache a policy. Typically 1 or several weeks. Units: s for seconds, m for minutes, h for hours, d for day, w for weeks.'), dom.div('Max age'), mtastsMaxAge = dom.input(attr.value(domainConfig.MTASTS?.MaxAge ? formatDuration(domainConfig.MTASTS?.MaxAge || 0) : ''))), dom.label(attr.title('List of server names allowed for SMTP. If empty, the configured hostname is set. Host names can contain a wildcard (*) as a leading label (matching a single label, e.g. *.example matches host.example, not sub.host.example).'), dom.div('MX hosts/patterns (optional)'), mtastsMX = dom.textarea(new String((domainConfig.MTASTS?.MX || []).join('\n')), attr.rows('' + Math.max(2, 1 + (domainConfig.MTASTS?.MX || []).length)))), dom.div(dom.span('\u00a0'), dom.div(dom.submitbutton('Save'))))), dom.br(), dom.h2('DKIM', attr.title('With DKIM signing, a domain is taking responsibility for (content of) emails it sends, letting receiving mail servers build up a (hopefully positive) reputation of the domain, which can help with mail delivery.')), (() => {
+	})), mtastsPolicyID = dom.input(attr.value(domainConfig.MTASTS?.PolicyID || ''))), dom.label(attr.title("If set to \"enforce\", a remote SMTP server will not deliver email to us if it cannot make a WebPKI-verified SMTP STARTTLS connection. In mode \"testing\", deliveries can be done without verified TLS, but errors will be reported through TLS reporting. In mode \"none\", verified TLS is not required, used for phasing out an MTA-STS policy."), dom.div('Mode'), mtastsMode = dom.select(dom.option(''), Object.values(api.Mode).map(s => dom.option(s, domainConfig.MTASTS?.Mode === s ? attr.selected('') : [])))), dom.label(attr.title('How long a remote mail server is allowed to cache a policy. Typically 1 or several weeks. Units: s for seconds, m for minutes, h for hours, d for day, w for weeks.'), dom.div('Max age'), mtastsMaxAge = dom.input(attr.value(domainConfig.MTASTS?.MaxAge ? formatDuration(domainConfig.MTASTS?.MaxAge || 0) : ''))), dom.label(attr.title('List of server names allowed for SMTP. If empty, the configured hostname is set. Host names can contain a wildcard (*) as a leading label (matching a single label, e.g. *.example matches host.example, not sub.host.example).'), dom.div('MX hosts/patterns (optional)'), mtastsMX = dom.textarea(new String((domainConfig.MTASTS?.MX || []).join('\n')), attr.rows('' + Math.max(2, 1 + (domainConfig.MTASTS?.MX || []).length)))), dom.label(attr.title('For a policy in mode "testing", automatically change the policy to mode "enforce" with a new policy ID after this period without TLS failures in received TLS reports. The period starts again after a TLS report with failures. Leave empty to not change the mode automatically. Units: s for seconds, m for minutes, h for hours, d for day, w for weeks.'), dom.div('Enforce after (optional)'), mtastsEnforceAfter = dom.input(attr.value(domainConfig.MTASTS?.EnforceAfter ? formatDuration(domainConfig.MTASTS?.EnforceAfter || 0) : ''))), dom.div(dom.span('\u00a0'), dom.div(dom.submitbutton('Save'))))), !mtastsPromotion.PolicyID ? [] : dom.p('Current mode: ', mtastsPromotion.Mode || 'none', '. ', mtastsPromotion.Mode !== api.Mode.ModeTesting || !mtastsPromotion.EnforceAfter ? [] : (mtastsPromotion.PromoteAt.getTime() > 0 ?
+		['Promotion to mode enforce in ', age(mtastsPromotion.PromoteAt, true, 0), ', based on ', '' + mtastsPromotion.CleanReports, ' TLS report(s) without failures', mtastsPromotion.LastFailure.getTime() > 0 ? [' since last failure ', age(mtastsPromotion.LastFailure, false, 0)] : [], '.'] :
+		['Waiting for TLS reports without failures before promotion to mode enforce', mtastsPromotion.LastFailure.getTime() > 0 ? [', last failure ', age(mtastsPromotion.LastFailure, false, 0)] : [], '.'])), dom.br(), dom.h2('DKIM', attr.title('With DKIM signing, a domain is taking responsibility for (content of) emails it sends, letting receiving mail servers build up a (hopefully positive) reputation of the domain, which can help with mail delivery.')), (() => {
 		let fieldset;
 		let rows = [];
 		return dom.form(async function submit(e) {
@@ -2629,14 +3857,26 @@ const domain = async (d) => {
 		})), dom.tfoot(dom.tr(dom.td(attr.colspan('9'), dom.submitbutton('Save'), ' ', dom.clickbutton('Add key/selector', function click() {
 			popupDKIMAdd();
 		})))))));
-	})(), dom.br(), dom.h2('External checks'), dom.ul(dom.li(link('https://internet.nl/mail/' + dnsdomain.ASCII + '/', 'Check configuration at internet.nl'))), dom.br(), dom.h2('Danger'), dom.clickbutton('Remove domain', async function click(e) {
-		e.preventDefault();
-		if (!window.confirm('Are you sure you want to remove this domain?')) {
-			return;
-		}
-		await check(e.target, client.DomainRemove(d));
-		window.location.hash = '#';
-	}));
+	})(), dom.br(), adminScope.LoginAddress ? [] : [
+		dom.h2('Domain admins', attr.title('Login addresses of accounts that can manage this domain in the admin web interface, with the email address and password of their account. Domain admins can manage accounts, addresses, aliases, DKIM keys and reporting addresses of their domains, and view DMARC and TLS reports. They cannot see other domains or server-wide settings.')),
+		dom.form(async function submit(e) {
+			e.preventDefault();
+			e.stopPropagation();
+			const admins = domainAdmins.value.split('\n').map(s => s.trim()).filter(s => s);
+			await check(domainAdminsFieldset, client.DomainAdminsSave(d, admins));
+		}, domainAdminsFieldset = dom.fieldset(style({ display: 'flex', gap: '1em' }), dom.label(dom.div('Login addresses, one per line'), domainAdmins = dom.textarea((domainConfig.Admins || []).join('\n'), attr.rows('3'), style({ width: '30em' }))), dom.div(dom.span('\u00a0'), dom.div(dom.submitbutton('Save'))))),
+		dom.br(),
+	], dom.h2('External checks'), dom.ul(dom.li(link('https://internet.nl/mail/' + dnsdomain.ASCII + '/', 'Check configuration at internet.nl'))), dom.br(), adminScope.LoginAddress ? [] : [
+		dom.h2('Danger'),
+		dom.clickbutton('Remove domain', async function click(e) {
+			e.preventDefault();
+			if (!window.confirm('Are you sure you want to remove this domain?')) {
+				return;
+			}
+			await check(e.target, client.DomainRemove(d));
+			window.location.hash = '#';
+		}),
+	]);
 };
 const domainAlias = async (d, aliasLocalpart) => {
 	const domain = await client.DomainConfig(d);
@@ -2648,16 +3888,19 @@ const domainAlias = async (d, aliasLocalpart) => {
 	let postPublic;
 	let listMembers;
 	let allowMsgFrom;
+	let moderated;
+	let ownerAddress;
 	let addFieldset;
 	let addAddress;
 	let delFieldset;
 	dom._kids(page, crumbs(crumblink('Mox Admin', '#'), crumblink('Domain ' + domainString(domain.Domain), '#domains/' + d), 'Alias ' + aliasLocalpart + '@' + domainName(domain.Domain)), dom.h2('Alias'), dom.form(async function submit(e) {
 		e.preventDefault();
 		e.stopPropagation();
-		check(aliasFieldset, client.AliasUpdate(aliasLocalpart, d, postPublic.checked, listMembers.checked, allowMsgFrom.checked));
-	}, aliasFieldset = dom.fieldset(style({ display: 'flex', flexDirection: 'column', gap: '.5ex' }), dom.label(postPublic = dom.input(attr.type('checkbox'), alias.PostPublic ? attr.checked('') : []), ' Public, anyone can post instead of only members'), dom.label(listMembers = dom.input(attr.type('checkbox'), alias.ListMembers ? attr.checked('') : []), ' Members can list other members'), dom.label(allowMsgFrom = dom.input(attr.type('checkbox'), alias.AllowMsgFrom ? attr.checked('') : []), ' Allow messages to use the alias address in the message From header'), dom.div(style({ marginTop: '1ex' }), dom.submitbutton('Save')))), dom.br(), dom.h2('Members'), dom.table(dom.thead(dom.tr(dom.th('Address'), dom.th('Account'), dom.th())), dom.tbody((alias.Addresses || []).map((address, index) => {
-		const pa = (alias.ParsedAddresses || [])[index];
-		return dom.tr(dom.td(prewrap(address)), dom.td(dom.a(pa.AccountName, attr.href('#accounts/' + pa.AccountName))), dom.td(dom.clickbutton('Remove', async function click(e) {
+		check(aliasFieldset, client.AliasUpdate(aliasLocalpart, d, postPublic.checked, listMembers.checked, allowMsgFrom.checked, ownerAddress.value.trim(), moderated.checked));
+	}, aliasFieldset = dom.fieldset(style({ display: 'flex', flexDirection: 'column', gap: '.5ex' }), dom.label(postPublic = dom.input(attr.type('checkbox'), alias.PostPublic ? attr.checked('') : []), ' Public, anyone can post instead of only members'), dom.label(listMembers = dom.input(attr.type('checkbox'), alias.ListMembers ? attr.checked('') : []), ' Members can list other members'), dom.label(allowMsgFrom = dom.input(attr.type('checkbox'), alias.AllowMsgFrom ? attr.checked('') : []), ' Allow messages to use the alias address in the message From header'), dom.label(moderated = dom.input(attr.type('checkbox'), alias.Moderated ? attr.checked('') : []), ' Moderated, hold messages from non-members for approval by the owner instead of rejecting them', attr.title('Only applies if the alias is not public. The owner address is sent a message with a link to approve or reject the held message.')), dom.label(dom.div('Owner address', attr.title('Local address that receives delivery failures for messages forwarded to external members, and requests to approve held messages. If empty, the postmaster address of the domain is used.')), ownerAddress = dom.input(attr.value(alias.OwnerAddress), attr.placeholder('postmaster@' + domainName(domain.Domain)))), dom.div(style({ marginTop: '1ex' }), dom.submitbutton('Save')))), dom.br(), dom.h2('Members'), dom.table(dom.thead(dom.tr(dom.th('Address'), dom.th('Account'), dom.th())), dom.tbody((alias.Addresses || []).map(address => {
+		// Addresses in domains that are not configured are external members, without account.
+		const pa = (alias.ParsedAddresses || []).find(pa => [pa.Address.Localpart + '@' + pa.Address.Domain.ASCII, pa.Address.Localpart + '@' + domainName(pa.Address.Domain)].some(s => s.toLowerCase() === address.toLowerCase()));
+		return dom.tr(dom.td(prewrap(address)), dom.td(pa ? dom.a(pa.AccountName, attr.href('#accounts/' + pa.AccountName)) : '(external)'), dom.td(dom.clickbutton('Remove', async function click(e) {
 			await check(e.target, client.AliasAddressesRemove(aliasLocalpart, d, [address]));
 			window.location.reload(); // todo: reload less
 		})));
@@ -2676,6 +3919,157 @@ const domainAlias = async (d, aliasLocalpart) => {
 		window.location.hash = '#domains/' + d;
 	}, delFieldset = dom.fieldset(dom.div(dom.submitbutton('Remove alias')))));
 };
+const domainSetup = async (d) => {
+	const dnsdomain = await client.ParseDomain(d);
+	const hash = window.location.hash;
+	const copyButton = (text) => dom.clickbutton('Copy', attr.title('Copy to clipboard.'), async function click(e) {
+		const b = e.target;
+		try {
+			await window.navigator.clipboard.writeText(text());
+			b.innerText = 'Copied';
+			setTimeout(() => b.innerText = 'Copy', 2000);
+		}
+		catch (err) {
+			window.alert('Error copying to clipboard: ' + errmsg(err));
+		}
+	});
+	// Name relative to the domain, as most DNS provider web interfaces expect.
+	const relativeName = (name) => {
+		const zone = dnsdomain.ASCII + '.';
+		if (name === zone) {
+			return '@';
+		}
+		else if (name.endsWith('.' + zone)) {
+			return name.substring(0, name.length - zone.length - 1);
+		}
+		return name;
+	};
+	// TXT records as quoted strings of at most 255 characters.
+	const rdata = (rr) => {
+		if (rr.Type !== 'TXT') {
+			return rr.Value;
+		}
+		const l = [];
+		for (let i = 0; i === 0 || i < rr.Value.length; i += 255) {
+			l.push('"' + rr.Value.substring(i, i + 255) + '"');
+		}
+		return l.join(' ');
+	};
+	const providers = {
+		webui: {
+			name: 'Web interface',
+			about: 'For DNS providers with a web interface for adding records one by one. Most ask for a name relative to the domain, with "@" for the domain itself. For TXT records, paste the value without quotes.',
+		},
+		zone: {
+			name: 'Zone file',
+			about: 'For DNS servers like BIND, NSD and Knot, and for DNS providers that can import records in zone file syntax, such as Cloudflare.',
+			snippet: (l) => ['$TTL 300', ...l.map(rr => rr.Zone)].join('\n') + '\n',
+		},
+		nsupdate: {
+			name: 'nsupdate',
+			about: 'For DNS servers that accept dynamic updates (RFC 2136), e.g. with "nsupdate -k keyfile". Existing records are not removed.',
+			snippet: (l) => [...l.map(rr => 'update add ' + rr.Name + ' 300 ' + rr.Type + ' ' + rdata(rr)), 'send'].join('\n') + '\n',
+		},
+		route53: {
+			name: 'Route 53',
+			about: 'For Amazon Route 53, as change batch for "aws route53 change-resource-record-sets --hosted-zone-id ... --change-batch file://records.json". An UPSERT replaces all existing records of the same name and type, add existing TXT records for the domain, e.g. for other verifications, before applying.',
+			snippet: (l) => {
+				const sets = {};
+				for (const rr of l) {
+					const k = rr.Name + ' ' + rr.Type;
+					sets[k] = sets[k] || { Name: rr.Name, Type: rr.Type, TTL: 300, ResourceRecords: [] };
+					sets[k].ResourceRecords.push({ Value: rdata(rr) });
+				}
+				const changes = Object.values(sets).map(rrset => ({ Action: 'UPSERT', ResourceRecordSet: rrset }));
+				return JSON.stringify({ Comment: 'Mail for ' + dnsdomain.ASCII, Changes: changes }, undefined, '\t') + '\n';
+			},
+		},
+	};
+	let provider = 'webui';
+	let status = null;
+	let stopped = false;
+	let statusBox;
+	let recordsBox;
+	let checkButton;
+	let activateButton;
+	const checkStatus = (name) => {
+		const c = (status?.Checks || []).find(c => c.Name === name);
+		if (!c) {
+			return dom.span('Optional', attr.title('Recommended, but not required for activating the setup.'));
+		}
+		else if ((c.Errors || []).length > 0) {
+			return dom.span(style({ backgroundColor: red, padding: '0 .25em', borderRadius: '3px' }), 'Not valid', attr.title((c.Errors || []).join('\n')));
+		}
+		return dom.span(style({ backgroundColor: green, padding: '0 .25em', borderRadius: '3px' }), 'OK');
+	};
+	const renderRecords = () => {
+		if (!status) {
+			return;
+		}
+		const records = status.Records || [];
+		const required = records.filter(rr => rr.Check);
+		const optional = records.filter(rr => !rr.Check);
+		const p = providers[provider];
+		const table = (l) => dom.table(dom._class('hover'), dom.thead(dom.tr(dom.th('Status'), dom.th('Name'), dom.th('Type'), dom.th('Value'))), dom.tbody(l.length === 0 ? dom.tr(dom.td(attr.colspan('4'), '(None)')) : [], l.map(rr => dom.tr(dom.td(checkStatus(rr.Check)), dom.td(relativeName(rr.Name), ' ', copyButton(() => relativeName(rr.Name))), dom.td(rr.Type), dom.td(dom.div(style({ maxWidth: '50em', wordBreak: 'break-all' }), rr.Value), copyButton(() => rr.Value))))));
+		const snippet = (l) => [
+			dom.pre(dom._class('literal'), style({ maxWidth: '70em', whiteSpace: 'pre-wrap', wordBreak: 'break-all' }), p.snippet(l)),
+			copyButton(() => p.snippet(l)),
+		];
+		dom._kids(recordsBox, dom.div(Object.entries(providers).map(([k, v]) => [
+			dom.clickbutton(v.name, k === provider ? style({ fontWeight: 'bold' }) : [], function click() {
+				provider = k;
+				renderRecords();
+			}),
+			' ',
+		])), dom.p(p.about), dom.h2('Required records'), p.snippet ? snippet(required) : table(required), dom.br(), dom.h2('Recommended records'), dom.p('These records are not required for activating the setup. Some are for the machine and only have to be created once, for the first domain.'), p.snippet ? snippet(optional) : table(optional));
+	};
+	const renderStatus = () => {
+		if (!status) {
+			return;
+		}
+		activateButton.disabled = !status.Ready;
+		dom._kids(statusBox, status.Ready ? box(green, 'All required DNS records are valid, the setup can be activated.') : box(yellow, 'Waiting for valid DNS records. Changes can take a while to be visible, depending on TTLs. Checking again every 30 seconds.'), dom.div('Last checked: ', status.Setup.LastCheck.toLocaleString()), dom.ul((status.Checks || []).map(c => dom.li(c.Name, ': ', checkStatus(c.Name), (c.Errors || []).length === 0 ? [] : dom.ul((c.Errors || []).map(s => dom.li(s)))))));
+	};
+	const refresh = async () => {
+		try {
+			checkButton.disabled = true;
+			status = await client.DomainSetupCheck(d);
+		}
+		catch (err) {
+			stopped = true;
+			dom._kids(statusBox, box(red, 'Error checking DNS records: ' + errmsg(err)));
+			return;
+		}
+		finally {
+			checkButton.disabled = false;
+		}
+		renderStatus();
+		renderRecords();
+	};
+	// Keep checking while the page is shown, until the records are valid.
+	const poll = async () => {
+		if (stopped || window.location.hash !== hash) {
+			return;
+		}
+		await refresh();
+		if (!stopped && !status?.Ready) {
+			setTimeout(poll, 30 * 1000);
+		}
+	};
+	dom._kids(page, crumbs(crumblink('Mox Admin', '#'), crumblink('Domain ' + domainString(dnsdomain), '#domains/' + d), 'Setup'), dom.p('The domain has been added, with new DKIM keys. Create the DNS records below. Once the required records are valid, activate the setup to start signing outgoing messages with DKIM, and to change the MTA-STS policy to mode enforce. Until then, outgoing messages are not DKIM-signed. You can leave this page and continue later from the domain page.'), dom.h2('Verification'), statusBox = dom.div('Checking DNS records...'), dom.div(checkButton = dom.clickbutton('Check now', attr.title('Check the DNS records again.'), async function click() {
+		await refresh();
+	}), ' ', activateButton = dom.clickbutton('Activate', attr.disabled(''), attr.title('Enable DKIM signing and change the MTA-STS policy to mode enforce. Only possible when all required DNS records are valid.'), async function click(e) {
+		const policyID = await check(e.target, client.DomainSetupActivate(d));
+		stopped = true;
+		const txt = '_mta-sts.' + dnsdomain.ASCII + '. TXT "v=STSv1; id=' + policyID + '"';
+		dom._kids(page, crumbs(crumblink('Mox Admin', '#'), crumblink('Domain ' + domainString(dnsdomain), '#domains/' + d), 'Setup'), box(green, 'Setup activated, outgoing messages are now signed with DKIM.'), policyID ? [
+			dom.p('The MTA-STS policy is now in mode enforce, with a new policy ID. Update the DNS record so remote mail servers fetch the new policy:'),
+			dom.pre(dom._class('literal'), txt),
+			copyButton(() => txt),
+		] : [], dom.p(dom.a('Back to domain', attr.href('#domains/' + d))));
+	})), dom.br(), dom.h2('DNS records'), recordsBox = dom.div());
+	await poll();
+};
 const domainDNSRecords = async (d) => {
 	const [records, dnsdomain] = await Promise.all([
 		client.DomainRecords(d),
@@ -3187,6 +4581,12 @@ const mtasts = async () => {
 	const policies = await client.MTASTSPolicies();
 	dom._kids(page, crumbs(crumblink('Mox Admin', '#'), 'MTA-STS policies'), dom.p("MTA-STS is a mechanism allowing email domains to publish a policy for using SMTP STARTTLS and TLS verification. See ", link('https://www.rfc-editor.org/rfc/rfc8461.html', 'RFC 8461'), '.'), dom.p("The SMTP protocol is unencrypted by default, though the SMTP STARTTLS command is typically used to enable TLS on a connection. However, MTA's using STARTTLS typically do not validate the TLS certificate. An MTA-STS policy can specify that validation of host name, non-expiration and webpki trust is required."), makeMTASTSTable(policies || []));
 };
+const acmeCertificates = async () => {
+	const certs = await client.ACMECertificates();
+	const nowSecs = new Date().getTime() / 1000;
+	dom._kids(page, crumbs(crumblink('Mox Admin', '#'), 'ACME certificates'), dom.p('TLS certificates requested through ACME, by host and ACME provider. When requesting a certificate from an ACME provider fails, certificates are requested from its fallback providers, if configured. Certificates are renewed early when the renewal information (ARI) of the ACME provider suggests so, e.g. when they will be revoked.'), (certs || []).length === 0 ? dom.div('No certificates') :
+		dom.table(dom._class('hover'), dom.thead(dom.tr(dom.th('Host'), dom.th('Provider', attr.title('Name of the ACME provider in the configuration.')), dom.th('Issuer', attr.title('Certificate authority that issued the certificate.')), dom.th('Active', attr.title('Whether the certificate is used for new connections. A certificate from a fallback provider is only used if the primary provider has no certificate.')), dom.th('DNS-01', attr.title('Whether the certificate was requested with a DNS-01 challenge.')), dom.th('Not before'), dom.th('Expires'), dom.th('Renewal window', attr.title('Renewal window suggested by the ACME provider through renewal information (ARI), if supported.')), dom.th('Serial number'))), dom.tbody((certs || []).map(c => dom.tr(dom.td(c.Host), dom.td(c.Provider), dom.td(c.IssuerOrganization + (c.IssuerCommonName ? ' (' + c.IssuerCommonName + ')' : '')), dom.td(c.Active ? 'yes' : 'no'), dom.td(c.DNS01 ? 'yes' : ''), dom.td(age(c.NotBefore, false, nowSecs)), dom.td(age(c.NotAfter, true, nowSecs)), dom.td(c.RenewalStart.getTime() < 0 ? '' : [age(c.RenewalStart, true, nowSecs), ' - ', age(c.RenewalEnd, true, nowSecs)]), dom.td(c.SerialNumber))))));
+};
 const formatMTASTSMX = (mx) => {
 	return mx.map(e => {
 		return (e.Wildcard ? '*.' : '') + e.Domain.ASCII;
@@ -3310,7 +4710,7 @@ const queueList = async () => {
 			toggles.set(m.ID, dom.input(attr.type('checkbox'), msgs.length === 1 ? attr.checked('') : []));
 		}
 		const ntbody = dom.tbody(dom._class('loadend'), msgs.length === 0 ? dom.tr(dom.td(attr.colspan('15'), 'No messages.')) : [], msgs.map(m => {
-			return dom.tr(dom.td(toggles.get(m.ID)), dom.td('' + m.ID + (m.BaseID > 0 ? '/' + m.BaseID : '')), dom.td(age(new Date(m.Queued), false, nowSecs)), dom.td(m.SenderAccount || '-'), dom.td(prewrap(m.SenderLocalpart, "@", ipdomainString(m.SenderDomain))), // todo: escaping of localpart
+			return dom.tr(dom.td(toggles.get(m.ID)), dom.td(dom.a('' + m.ID, attr.href('#messagetrace/' + m.ID), attr.title('Delivery history of message.')), m.BaseID > 0 ? '/' + m.BaseID : ''), dom.td(age(new Date(m.Queued), false, nowSecs)), dom.td(m.SenderAccount || '-'), dom.td(prewrap(m.SenderLocalpart, "@", ipdomainString(m.SenderDomain))), // todo: escaping of localpart
 			dom.td(prewrap(m.RecipientLocalpart, "@", ipdomainString(m.RecipientDomain))), // todo: escaping of localpart
 			dom.td(formatSize(m.Size)), dom.td('' + m.Attempts), dom.td(m.Hold ? 'Hold' : ''), dom.td(age(new Date(m.NextAttempt), true, nowSecs)), dom.td(m.LastAttempt ? age(new Date(m.LastAttempt), false, nowSecs) : '-'), dom.td(m.Results && m.Results.length > 0 ? m.Results[m.Results.length - 1].Error : []), dom.td(m.Transport || '(default)'), dom.td(m.RequireTLS === true ? 'Yes' : (m.RequireTLS === false ? 'No' : '')), dom.td(dom.clickbutton('Details', function click() {
 				popupDetails(m);
@@ -3498,7 +4898,7 @@ const retiredList = async () => {
 	};
 	let tbody = dom.tbody();
 	const render = () => {
-		const ntbody = dom.tbody(dom._class('loadend'), retired.length === 0 ? dom.tr(dom.td(attr.colspan('14'), 'No retired messages.')) : [], retired.map(m => dom.tr(dom.td('' + m.ID + (m.BaseID > 0 ? '/' + m.BaseID : '')), dom.td(m.Success ? '✓' : ''), dom.td(age(new Date(m.LastActivity), false, nowSecs)), dom.td(age(new Date(m.Queued), false, nowSecs)), dom.td(m.SenderAccount || '-'), dom.td(prewrap(m.SenderLocalpart, "@", m.SenderDomainStr)), // todo: escaping of localpart
+		const ntbody = dom.tbody(dom._class('loadend'), retired.length === 0 ? dom.tr(dom.td(attr.colspan('14'), 'No retired messages.')) : [], retired.map(m => dom.tr(dom.td(dom.a('' + m.ID, attr.href('#messagetrace/' + m.ID), attr.title('Delivery history of message.')), m.BaseID > 0 ? '/' + m.BaseID : ''), dom.td(m.Success ? '✓' : ''), dom.td(age(new Date(m.LastActivity), false, nowSecs)), dom.td(age(new Date(m.Queued), false, nowSecs)), dom.td(m.SenderAccount || '-'), dom.td(prewrap(m.SenderLocalpart, "@", m.SenderDomainStr)), // todo: escaping of localpart
 		dom.td(prewrap(m.RecipientLocalpart, "@", m.RecipientDomainStr)), // todo: escaping of localpart
 		dom.td(formatSize(m.Size)), dom.td('' + m.Attempts), dom.td(m.LastAttempt ? age(new Date(m.LastAttempt), false, nowSecs) : '-'), dom.td(m.Results && m.Results.length > 0 ? m.Results[m.Results.length - 1].Error : []), dom.td(m.Transport || ''), dom.td(m.RequireTLS === true ? 'Yes' : (m.RequireTLS === false ? 'No' : '')), dom.td(dom.clickbutton('Details', function click() {
 			popupDetails(m);
@@ -3900,6 +5300,8 @@ const webserver = async () => {
 			let responseHeaders = makeHeaders(wf.ResponseHeaders || {});
 			const get = () => {
 				return {
+					// Keep fields that can only be configured in the config file.
+					...wf,
 					StripPath: stripPath.checked,
 					URL: url.value,
 					ResponseHeaders: responseHeaders.get(),
@@ -3947,6 +5349,9 @@ const webserver = async () => {
 					StripPath: false,
 					URL: '',
 					ResponseHeaders: {},
+					HTTP2: false,
+					DialTimeout: 0,
+					ResponseTimeout: 0,
 				});
 				detailsRoot(forwardView.root);
 			}
@@ -3993,6 +5398,10 @@ const webserver = async () => {
 				moveHandler(row, index, handlerRows.length - 1);
 			}
 		})))));
+		// Access control, rate limit and rules can only be configured in the config file, keep them.
+		const access = wh.Access;
+		const rateLimit = wh.RateLimit;
+		const rules = wh.Rules;
 		// Final "get" that returns a WebHandler that reflects the UI.
 		const get = () => {
 			const wh = {
@@ -4001,6 +5410,9 @@ const webserver = async () => {
 				PathRegexp: pathRegexp.value,
 				DontRedirectPlainHTTP: !toHTTPS.checked,
 				Compress: compress.checked,
+				Access: access,
+				RateLimit: rateLimit,
+				Rules: rules,
 				Name: '',
 				DNSDomain: { ASCII: '', Unicode: '' },
 			};
@@ -4059,6 +5471,9 @@ const webserver = async () => {
 					WebForward: {
 						StripPath: true,
 						URL: '',
+						HTTP2: false,
+						DialTimeout: 0,
+						ResponseTimeout: 0,
 					},
 					Name: '',
 					DNSDomain: { ASCII: '', Unicode: '' },
@@ -4086,6 +5501,9 @@ const webserver = async () => {
 	}));
 };
 const init = async () => {
+	await i18nLoad();
+	await oidcLoginFinish();
+	adminScope = await client.AdminScope();
 	let curhash;
 	const hashChange = async () => {
 		if (curhash === window.location.hash) {
@@ -4107,6 +5525,42 @@ const init = async () => {
 			else if (h === 'loglevels') {
 				await loglevels();
 			}
+			else if (h === 'passkeys') {
+				await passkeys();
+			}
+			else if (h === 'apitokens') {
+				await apitokens();
+			}
+			else if (h === 'auditlog') {
+				await auditlog();
+			}
+			else if (h === 'quarantine') {
+				await quarantine();
+			}
+			else if (h === 'spamtraps') {
+				await spamtraps();
+			}
+			else if (h === 'lineendings') {
+				await lineendings();
+			}
+			else if (h === 'autobans') {
+				await autobans();
+			}
+			else if (h === 'abusereports') {
+				await abusereports();
+			}
+			else if (h === 'logs') {
+				await logs();
+			}
+			else if (h === 'usage') {
+				await usage();
+			}
+			else if (h === 'messagetrace') {
+				await messagetrace('');
+			}
+			else if (h.startsWith('messagetrace/')) {
+				await messagetrace(h.substring('messagetrace/'.length));
+			}
 			else if (h === 'accounts') {
 				await accounts();
 			}
@@ -4131,6 +5585,9 @@ const init = async () => {
 			else if (t[0] === 'domains' && t.length === 3 && t[2] === 'dnsrecords') {
 				await domainDNSRecords(t[1]);
 			}
+			else if (t[0] === 'domains' && t.length === 3 && t[2] === 'setup') {
+				await domainSetup(t[1]);
+			}
 			else if (h === 'queue') {
 				await queueList();
 			}
@@ -4179,9 +5636,15 @@ const init = async () => {
 			else if (h === 'dnsbl') {
 				await dnsbl();
 			}
+			else if (h === 'acmecertificates') {
+				await acmeCertificates();
+			}
 			else if (h === 'routes') {
 				await globalRoutes();
 			}
+			else if (h === 'addressrewrites') {
+				await globalAddressRewrites();
+			}
 			else if (h === 'webserver') {
 				await webserver();
 			}
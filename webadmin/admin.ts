@@ -7,12 +7,78 @@ declare let moxgoversion: string
 declare let moxgoos: string
 declare let moxgoarch: string
 
+// Conversion between base64url strings in the API and binary values for WebAuthn.
+const base64urlDecode = (s: string) => Uint8Array.from(atob(s.replace(/-/g, '+').replace(/_/g, '/')), c => c.charCodeAt(0))
+const base64urlEncode = (buf: ArrayBuffer) => btoa(String.fromCharCode(...new Uint8Array(buf))).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '')
+
+// passkeyLogin asks the browser for a passkey and logs in with it, returning the
+// CSRF token.
+const passkeyLogin = async () => {
+	const opts = await client.PasskeyLoginPrep()
+	const cred = await window.navigator.credentials.get({
+		publicKey: {
+			challenge: base64urlDecode(opts.Challenge),
+			rpId: opts.RPID,
+			timeout: opts.Timeout,
+			userVerification: 'required',
+		},
+	}) as PublicKeyCredential | null
+	if (!cred) {
+		throw new Error('no passkey selected')
+	}
+	const resp = cred.response as AuthenticatorAssertionResponse
+	// The login token cookie is short-lived, so only request it now.
+	const loginToken = await client.LoginPrep()
+	return await client.PasskeyLogin(loginToken, {
+		CredentialID: cred.id,
+		ClientDataJSON: base64urlEncode(resp.clientDataJSON),
+		AuthenticatorData: base64urlEncode(resp.authenticatorData),
+		Signature: base64urlEncode(resp.signature),
+		UserHandle: resp.userHandle ? base64urlEncode(resp.userHandle) : '',
+	})
+}
+
+// passkeyCreate asks the browser to create a new passkey for registration.
+const passkeyCreate = async (opts: api.PasskeyCreationOptions): Promise<api.PasskeyAttestation> => {
+	const cred = await window.navigator.credentials.create({
+		publicKey: {
+			challenge: base64urlDecode(opts.Challenge),
+			rp: {id: opts.RPID, name: opts.RPName},
+			user: {id: base64urlDecode(opts.UserID), name: opts.UserName, displayName: opts.UserDisplayName},
+			pubKeyCredParams: (opts.Algorithms || []).map(alg => ({type: 'public-key' as const, alg: alg})),
+			excludeCredentials: (opts.ExcludeCredentialIDs || []).map(id => ({type: 'public-key' as const, id: base64urlDecode(id)})),
+			authenticatorSelection: {residentKey: 'required', userVerification: 'required'},
+			attestation: 'none',
+			timeout: opts.Timeout,
+		},
+	}) as PublicKeyCredential | null
+	if (!cred) {
+		throw new Error('no passkey created')
+	}
+	const resp = cred.response as AuthenticatorAttestationResponse
+	return {
+		ClientDataJSON: base64urlEncode(resp.clientDataJSON),
+		AttestationObject: base64urlEncode(resp.attestationObject),
+	}
+}
+
 const login = async (reason: string) => {
-	return new Promise<string>((resolve: (v: string) => void, _) => {
+	// Single sign-on is optional, don't prevent a login if we cannot find out.
+	let oidcEnabled = false
+	try {
+		oidcEnabled = await client.OIDCEnabled()
+	} catch (err) {
+		console.log('checking single sign-on', err)
+	}
+
+	return new Promise<string>((resolve: (v: string) => void, _reject) => {
 		const origFocus = document.activeElement
 		let reasonElem: HTMLElement
 		let fieldset: HTMLFieldSetElement
+		let username: HTMLInputElement
 		let password: HTMLInputElement
+		let totpBox: HTMLElement
+		let totpCode: HTMLInputElement
 		const root = dom.div(
 			style({position: 'absolute', top: 0, right: 0, bottom: 0, left: 0, backgroundColor: '#eee', display: 'flex', alignItems: 'center', justifyContent: 'center', zIndex: '1', animation: 'fadein .15s ease-in'}),
 			dom.div(
@@ -29,7 +95,7 @@ const login = async (reason: string) => {
 							try {
 								fieldset.disabled = true
 								const loginToken = await client.LoginPrep()
-								const token = await client.Login(loginToken, password.value)
+								const token = await client.Login(loginToken, username.value, password.value, totpCode.value)
 								try {
 									window.localStorage.setItem('webadmincsrftoken', token)
 								} catch (err) {
@@ -41,6 +107,13 @@ const login = async (reason: string) => {
 								}
 								resolve(token)
 							} catch (err) {
+								if ((err as any).code === 'user:totpRequired') {
+									fieldset.disabled = false
+									totpBox.style.display = ''
+									totpCode.required = true
+									totpCode.focus()
+									return
+								}
 								console.log('login error', err)
 								window.alert('Error: ' + errmsg(err))
 							} finally {
@@ -48,15 +121,64 @@ const login = async (reason: string) => {
 							}
 						},
 						fieldset=dom.fieldset(
-							dom.h1('Admin'),
+							dom.h1(_('Admin')),
+							dom.label(
+								style({display: 'block', marginBottom: '2ex'}),
+								dom.div(_('Email address'), attr.title('Only for domain admins, who log in with the email address and password of their account. Leave empty for the server admin.'), style({marginBottom: '.5ex'})),
+								username=dom.input(attr.autocomplete('username'), attr.placeholder('Empty for server admin')),
+							),
 							dom.label(
 								style({display: 'block', marginBottom: '2ex'}),
-								dom.div('Password', style({marginBottom: '.5ex'})),
+								dom.div(_('Password'), style({marginBottom: '.5ex'})),
 								password=dom.input(attr.type('password'), attr.required('')),
 							),
+							totpBox=dom.label(
+								style({display: 'none', marginBottom: '2ex'}),
+								dom.div(_('Two-factor authentication code'), style({marginBottom: '.5ex'})),
+								totpCode=dom.input(attr.autocomplete('one-time-code')),
+							),
 							dom.div(
 								style({textAlign: 'center'}),
-								dom.submitbutton('Login'),
+								dom.submitbutton(_('Login')),
+								window.PublicKeyCredential ? [
+									' ',
+									dom.clickbutton(_('Login with passkey'), async function click() {
+										reasonElem.remove()
+
+										try {
+											fieldset.disabled = true
+											const token = await passkeyLogin()
+											try {
+												window.localStorage.setItem('webadmincsrftoken', token)
+											} catch (err) {
+												console.log('saving csrf token in localStorage', err)
+											}
+											root.remove()
+											if (origFocus && origFocus instanceof HTMLElement && origFocus.parentNode) {
+												origFocus.focus()
+											}
+											resolve(token)
+										} catch (err) {
+											console.log('passkey login error', err)
+											window.alert('Error: ' + errmsg(err))
+										} finally {
+											fieldset.disabled = false
+										}
+									}),
+								] : [],
+								oidcEnabled ? [
+									' ',
+									dom.clickbutton(_('Login with single sign-on'), async function click() {
+										try {
+											fieldset.disabled = true
+											window.location.href = await client.OIDCLoginPrep()
+										} catch (err) {
+											console.log('single sign-on error', err)
+											window.alert('Error: ' + errmsg(err))
+											fieldset.disabled = false
+										}
+									}),
+								] : [],
 							),
 						),
 					)
@@ -64,10 +186,41 @@ const login = async (reason: string) => {
 			)
 		)
 		document.body.appendChild(root)
-		password.focus()
+		username.focus()
 	})
 }
 
+// oidcLoginFinish finishes a single sign-on login when the identity provider sent
+// the browser back with an authorization code.
+const oidcLoginFinish = async () => {
+	const params = new URLSearchParams(window.location.search)
+	const code = params.get('code')
+	const state = params.get('state')
+	const error = params.get('error')
+	if (!state || (!code && !error)) {
+		return
+	}
+	// Remove the parameters, they can only be used once.
+	window.history.replaceState(null, '', window.location.pathname + window.location.hash)
+	if (error) {
+		window.alert('Error: single sign-on failed: ' + (params.get('error_description') || error))
+		return
+	}
+	try {
+		const loginToken = await client.LoginPrep()
+		const token = await client.OIDCLogin(loginToken, code || '', state)
+		try {
+			window.localStorage.setItem('webadmincsrftoken', token)
+		} catch (err) {
+			console.log('saving csrf token in localStorage', err)
+		}
+		client.authState.token = token
+	} catch (err) {
+		console.log('single sign-on login error', err)
+		window.alert('Error: ' + errmsg(err))
+	}
+}
+
 // Popup shows kids in a centered div with white background on top of a
 // transparent overlay on top of the window. Clicking the overlay or hitting
 // Escape closes the popup. Scrollbars are automatically added to the div with
@@ -127,6 +280,9 @@ const localStorageRemove = (k: string) => {
 
 const client = new api.Client().withOptions({csrfHeader: 'x-mox-csrf', login: login}).withAuthToken(localStorageGet('webadmincsrftoken') || '')
 
+// Whether we are logged in as server admin, or as domain admin with limited access.
+let adminScope: api.AdminScope = {LoginAddress: '', Domains: []}
+
 const check = async <T>(elem: {disabled: boolean}, p: Promise<T>): Promise<T> => {
 	try {
 		elem.disabled = true
@@ -165,7 +321,17 @@ const crumbs = (...l: ({text: string, path: string} | string)[]) => {
 	return [
 		dom.div(
 			style({float: 'right'}),
-			dom.clickbutton('Logout', attr.title('Logout, invalidating this session.'), async function click(e: MouseEvent) {
+			dom.select(
+				attr.title(_('Language of the user interface.')),
+				dom.option(attr.value(''), _('Browser default')),
+				i18nLanguages.map(l => dom.option(attr.value(l.Code), l.Name, l.Code === i18nPreference() ? attr.selected('') : [])),
+				function change(e: Event) {
+					i18nPreferenceSet((e.target as HTMLSelectElement).value)
+					window.location.reload()
+				},
+			),
+			' ',
+			dom.clickbutton(_('Logout'), attr.title(_('Logout, invalidating this session.')), async function click(e: MouseEvent) {
 				const b = e.target! as HTMLButtonElement
 				try {
 					b.disabled = true
@@ -328,13 +494,39 @@ const formatSize = (n: number) => {
 	return n + ' bytes'
 }
 
+const domainAdminIndex = async () => {
+	const accounts = await client.Accounts()
+
+	dom._kids(page,
+		crumbs('Mox Admin'),
+		dom.p('Logged in as domain admin ', prewrap(adminScope.LoginAddress), '.'),
+		dom.p(
+			dom.a('Accounts', attr.href('#accounts')), ' ('+(accounts || []).length+')',
+		),
+		dom.p(dom.a('Quarantine', attr.href('#quarantine'))),
+		dom.p(dom.a('Spamtrap hits', attr.href('#spamtraps'))),
+		dom.h2('Domains'),
+		(adminScope.Domains || []).length === 0 ? box(red, 'No domains') :
+		dom.ul(
+			(adminScope.Domains || []).map(d => dom.li(dom.a(attr.href('#domains/'+domainName(d)), domainString(d)))),
+		),
+		footer,
+	)
+}
+
 const index = async () => {
-	const [domains, queueSize, hooksQueueSize, checkUpdatesEnabled, accounts] = await Promise.all([
+	if (adminScope.LoginAddress) {
+		await domainAdminIndex()
+		return
+	}
+
+	const [domains, queueSize, hooksQueueSize, checkUpdatesEnabled, accounts, setups] = await Promise.all([
 		client.Domains(),
 		client.QueueSize(),
 		client.HookQueueSize(),
 		client.CheckUpdatesEnabled(),
 		client.Accounts(),
+		client.DomainSetups(),
 	])
 
 	let fieldset: HTMLFieldSetElement
@@ -359,14 +551,22 @@ const index = async () => {
 		dom.ul(
 			(domains || []).map(d => dom.li(dom.a(attr.href('#domains/'+domainName(d)), domainString(d)))),
 		),
+		(setups || []).length === 0 ? [] : [
+			dom.br(),
+			dom.h2('Domain setups in progress'),
+			dom.p('Outgoing messages for these domains are not DKIM-signed until their DNS records are verified and the setup is activated.'),
+			dom.ul(
+				(setups || []).map(s => dom.li(dom.a(attr.href('#domains/'+s.Domain+'/setup'), s.Domain))),
+			),
+		],
 		dom.br(),
 		dom.h2('Add domain'),
 		dom.form(
 			async function submit(e: SubmitEvent) {
 				e.preventDefault()
 				e.stopPropagation()
-				await check(fieldset, client.DomainAdd(domain.value, account.value, localpart.value))
-				window.location.hash = '#domains/' + domain.value
+				await check(fieldset, client.DomainSetupAdd(domain.value, account.value, localpart.value))
+				window.location.hash = '#domains/' + domain.value + '/setup'
 			},
 			fieldset=dom.fieldset(
 				dom.label(
@@ -391,19 +591,28 @@ const index = async () => {
 					localpart=dom.input(),
 				),
 				' ',
-				dom.submitbutton('Add domain', attr.title('Domain will be added and the config reloaded. Add the required DNS records after adding the domain.')),
+				dom.submitbutton('Add domain', attr.title('Domain will be added with new DKIM keys and the config reloaded. Next, the DNS records to create are shown and checked. Outgoing messages are DKIM-signed once the records are valid and the setup is activated.')),
 			),
 		),
 		dom.br(),
 		dom.h2('Reports'),
 		dom.div(dom.a('DMARC', attr.href('#dmarc/reports'))),
 		dom.div(dom.a('TLS', attr.href('#tlsrpt/reports'))),
+		dom.div(dom.a('Abuse', attr.href('#abusereports'))),
 		dom.br(),
 		dom.h2('Operations'),
 		dom.div(dom.a('MTA-STS policies', attr.href('#mtasts'))),
 		dom.div(dom.a('DMARC evaluations', attr.href('#dmarc/evaluations'))),
 		dom.div(dom.a('TLS connection results', attr.href('#tlsrpt/results'))),
 		dom.div(dom.a('DNSBL', attr.href('#dnsbl'))),
+		dom.div(dom.a('ACME certificates', attr.href('#acmecertificates'))),
+		dom.div(dom.a('Quarantine', attr.href('#quarantine'))),
+		dom.div(dom.a('Spamtrap hits', attr.href('#spamtraps'))),
+		dom.div(dom.a('Line ending anomalies', attr.href('#lineendings'))),
+		dom.div(dom.a('Automatic bans', attr.href('#autobans'))),
+		dom.div(dom.a('Recent log', attr.href('#logs'))),
+		dom.div(dom.a('Message trace', attr.href('#messagetrace'))),
+		dom.div(dom.a('Usage', attr.href('#usage'))),
 		dom.div(
 			style({marginTop: '.5ex'}),
 			dom.form(
@@ -425,9 +634,13 @@ const index = async () => {
 		dom.br(),
 		dom.h2('Configuration'),
 		dom.div(dom.a('Routes', attr.href('#routes'))),
+		dom.div(dom.a('Address rewrites', attr.href('#addressrewrites'))),
 		dom.div(dom.a('Webserver', attr.href('#webserver'))),
 		dom.div(dom.a('Files', attr.href('#config'))),
 		dom.div(dom.a('Log levels', attr.href('#loglevels'))),
+		dom.div(dom.a('Passkeys', attr.href('#passkeys'))),
+		dom.div(dom.a('API tokens', attr.href('#apitokens'))),
+		dom.div(dom.a('Audit log', attr.href('#auditlog'))),
 		footer,
 	)
 }
@@ -447,9 +660,33 @@ const globalRoutes = async () => {
 	)
 }
 
+const globalAddressRewrites = async () => {
+	const config = await client.Config()
+
+	dom._kids(page,
+		crumbs(
+			crumblink('Mox Admin', '#'),
+			'Address rewrites',
+		),
+		AddressRewritesEditor('global', config.AddressRewrites || [], async (rules: api.AddressRewrite[]) => await client.AddressRewritesSave(rules)),
+	)
+}
+
 const config = async () => {
 	const [staticPath, dynamicPath, staticText, dynamicText] = await client.ConfigFiles()
 
+	let reloadBox: HTMLElement
+	const showReload = (r: api.StaticReload) => {
+		const changed = r.Changed || []
+		const restart = r.Restart || []
+		dom._kids(reloadBox,
+			r.Diff ? dom.pre(dom._class('literal'), r.Diff) : [],
+			!r.Diff && changed.length === 0 && restart.length === 0 ? dom.p('No changes.') : [],
+			changed.length > 0 ? dom.p((r.Applied ? 'Applied: ' : 'Will be applied: ') + changed.join(', ')) : [],
+			restart.length > 0 ? dom.p('Only effective after a restart: ' + restart.join(', ')) : [],
+		)
+	}
+
 	dom._kids(page,
 		crumbs(
 			crumblink('Mox Admin', '#'),
@@ -457,183 +694,973 @@ const config = async () => {
 		),
 		dom.h2(staticPath),
 		dom.pre(dom._class('literal'), staticText),
+		dom.p('Changes to this file can be applied without restart for most settings, but not for listeners, ACME and the data directory. The file is validated first, along with the domains file, and nothing changes if it has errors. Reloading also resets log levels to those in the file. A reload is also done on SIGHUP.'),
+		dom.div(
+			dom.clickbutton('Preview reload', attr.title('Show the changes in the config file compared to the running configuration, without applying them.'), async function click(e: MouseEvent) {
+				const r = await check(e.target! as HTMLButtonElement, client.ConfigReloadPreview())
+				showReload(r)
+			}),
+			' ',
+			dom.clickbutton('Reload', attr.title('Apply changes in the config file that take effect without restart.'), async function click(e: MouseEvent) {
+				const r = await check(e.target! as HTMLButtonElement, client.ConfigReload())
+				showReload(r)
+			}),
+		),
+		reloadBox=dom.div(),
 		dom.h2(dynamicPath),
 		dom.pre(dom._class('literal'), dynamicText),
 	)
 }
 
-const loglevels = async () => {
-	const loglevels = await client.LogLevels()
-
-	const levels = ['error', 'info', 'warn', 'debug', 'trace', 'traceauth', 'tracedata']
+const passkeys = async () => {
+	const passkeys = await client.Passkeys() || []
+	const nowSecs = new Date().getTime()/1000
 
-	let form: HTMLFormElement
 	let fieldset: HTMLFieldSetElement
-	let pkg: HTMLInputElement
-	let level: HTMLSelectElement
+	let label: HTMLInputElement
 
 	dom._kids(page,
 		crumbs(
 			crumblink('Mox Admin', '#'),
-			'Log levels',
+			'Passkeys',
 		),
-		dom.p('Note: changing a log level here only changes it for the current process. When mox restarts, it sets the log levels from the configuration file. Change mox.conf to keep the changes.'),
-		dom.table(
+		dom.p('Passkeys let you log in to the admin web interface without password, with a device or security key that verifies it is you. No two-factor authentication code is needed when logging in with a passkey.'),
+		dom.table(dom._class('hover'),
 			dom.thead(
 				dom.tr(
-					dom.th('Package', attr.title('Log levels can be configured per package. E.g. smtpserver, imapserver, dkim, dmarc, tlsrpt, etc.')),
-					dom.th('Level', attr.title('If you set the log level to "trace", imap and smtp protocol transcripts will be logged. Sensitive authentication is replaced with "***" unless the level is >= "traceauth". Data is masked with "..." unless the level is "tracedata".')),
+					dom.th('Label'),
+					dom.th('Created'),
+					dom.th('Last used'),
 					dom.th('Action'),
 				),
 			),
 			dom.tbody(
-				Object.entries(loglevels).map(t => {
-					let lvl: HTMLSelectElement
-					return dom.tr(
-						dom.td(t[0] || '(default)'),
+				passkeys.length === 0 ? dom.tr(dom.td(attr.colspan('4'), '(None)')) : [],
+				passkeys.map(pk =>
+					dom.tr(
+						dom.td(pk.Label),
+						dom.td(age(pk.Created, false, nowSecs)),
+						dom.td(pk.LastUsed.getTime() > 0 ? age(pk.LastUsed, false, nowSecs) : 'Never'),
 						dom.td(
-							lvl=dom.select(levels.map(l => dom.option(l, t[1] === l ? attr.selected('') : []))),
+							dom.clickbutton('Remove', async function click(e: MouseEvent) {
+								if (!window.confirm('Are you sure you want to remove this passkey?')) {
+									return
+								}
+								await check(e.target! as HTMLButtonElement, client.PasskeyRemove(pk.ID))
+								window.location.reload() // todo: reload less
+							}),
 						),
+					),
+				),
+			),
+		),
+		dom.br(),
+		window.PublicKeyCredential ?
+			dom.form(
+				async function submit(e: SubmitEvent) {
+					e.preventDefault()
+					e.stopPropagation()
+
+					await check(fieldset, (async () => {
+						const opts = await client.PasskeyRegisterPrep()
+						const response = await passkeyCreate(opts)
+						await client.PasskeyRegister(label.value, response)
+					})())
+					window.location.reload() // todo: reload less
+				},
+				fieldset=dom.fieldset(
+					dom.label('Label ', label=dom.input(attr.required(''), attr.placeholder('e.g. laptop'))), ' ',
+					dom.submitbutton('Add passkey'),
+				),
+			) :
+			dom.p('Your browser does not support passkeys.'),
+	)
+}
+
+const apitokens = async () => {
+	const tokens = await client.APITokens() || []
+	const nowSecs = new Date().getTime()/1000
+
+	let fieldset: HTMLFieldSetElement
+	let name: HTMLInputElement
+	let role: HTMLSelectElement
+	let tokenElem: HTMLElement
+
+	dom._kids(page,
+		crumbs(
+			crumblink('Mox Admin', '#'),
+			'API tokens',
+		),
+		dom.p('Tokens for the admin API, for provisioning domains, accounts, addresses and aliases, and managing the queue. The role of a token determines which methods it can call. The admin API must be enabled in a listener with AdminAPIHTTP or AdminAPIHTTPS.'),
+		dom.table(dom._class('hover'),
+			dom.thead(
+				dom.tr(
+					dom.th('Name'),
+					dom.th('Role'),
+					dom.th('Created'),
+					dom.th('Last used'),
+					dom.th('Action'),
+				),
+			),
+			dom.tbody(
+				tokens.length === 0 ? dom.tr(dom.td(attr.colspan('5'), '(None)')) : [],
+				tokens.map(t =>
+					dom.tr(
+						dom.td(t.Name),
+						dom.td(t.Role || 'admin'),
+						dom.td(age(t.Created, false, nowSecs)),
+						dom.td(t.LastUsed.getTime() > 0 ? age(t.LastUsed, false, nowSecs) : 'Never'),
 						dom.td(
-							dom.clickbutton('Save', attr.title('Set new log level for package.'), async function click(e: MouseEvent) {
-								e.preventDefault()
-								await check(e.target! as HTMLButtonElement, client.LogLevelSet(t[0], lvl.value))
-								window.location.reload() // todo: reload just the current loglevels
-							}),
-							' ',
-							dom.clickbutton('Remove', attr.title('Remove this log level, the default log level will apply.'), t[0] === '' ? attr.disabled('') : [], async function click(e: MouseEvent) {
-								e.preventDefault()
-								await check(e.target! as HTMLButtonElement, client.LogLevelRemove(t[0]))
-								window.location.reload() // todo: reload just the current loglevels
+							dom.clickbutton('Remove', async function click(e: MouseEvent) {
+								if (!window.confirm('Are you sure you want to remove this token? Systems using it can no longer call the admin API.')) {
+									return
+								}
+								await check(e.target! as HTMLButtonElement, client.APITokenRemove(t.Name))
+								window.location.reload() // todo: reload less
 							}),
 						),
-					)
-				}),
+					),
+				),
 			),
 		),
 		dom.br(),
-		dom.h2('Add log level setting'),
-		form=dom.form(
+		dom.h2('Add token'),
+		dom.form(
 			async function submit(e: SubmitEvent) {
 				e.preventDefault()
 				e.stopPropagation()
-				await check(fieldset, client.LogLevelSet(pkg.value, level.value))
-				form.reset()
-				window.location.reload() // todo: reload just the current loglevels
+
+				const token = await check(fieldset, client.APITokenAdd(name.value, role.value as api.Role))
+				dom._kids(tokenElem,
+					dom.p('Token added. It is only shown once, store it now. Pass it to the admin API in an "Authorization: Bearer <token>" HTTP header.'),
+					dom.pre(dom._class('literal'), token),
+					dom.clickbutton('Done', function click() {
+						window.location.reload() // todo: reload less
+					}),
+				)
 			},
 			fieldset=dom.fieldset(
 				dom.label(
 					style({display: 'inline-block'}),
-					'Package',
+					'Name',
 					dom.br(),
-					pkg=dom.input(attr.required('')),
+					name=dom.input(attr.required(''), attr.placeholder('e.g. provisioning')),
 				),
 				' ',
 				dom.label(
 					style({display: 'inline-block'}),
-					'Level',
+					dom.span('Role', attr.title('readonly: only methods that make no changes.\nqueue: read-only methods, and managing the outgoing queue.\ndomains: read-only methods, and managing domains, accounts, addresses and aliases.\nadmin: all methods, including the audit log.')),
 					dom.br(),
-					level=dom.select(
+					role=dom.select(
 						attr.required(''),
-						levels.map(l => dom.option(l, l === 'debug' ? attr.selected('') : [])),
+						Object.values(api.Role).map(r => dom.option(r, r === api.Role.RoleDomains ? attr.selected('') : [])),
 					),
 				),
 				' ',
-				dom.submitbutton('Add'),
+				dom.submitbutton('Add token'),
 			),
-			dom.br(),
-			dom.p('Suggestions for packages: autotls dkim dmarc dmarcdb dns dnsbl dsn http imapserver iprev junk message metrics mox moxio mtasts mtastsdb publicsuffix queue sendmail serve smtpserver spf store subjectpass tlsrpt tlsrptdb updates'),
 		),
+		tokenElem=dom.div(),
 	)
 }
 
-const box = (color: string, ...l: ElemArg[]) => [
-	dom.div(
-		style({
-			display: 'inline-block',
-			padding: '.25em .5em',
-			backgroundColor: color,
-			borderRadius: '3px',
-			margin: '.5ex 0',
-		}),
-		l,
-	),
-	dom.br(),
-]
-const inlineBox = (color: string, ...l: ElemArg[]) =>
-	dom.span(
-		style({
-			display: 'inline-block',
-			padding: color ? '0.05em 0.2em' : '',
-			backgroundColor: color,
-			borderRadius: '3px',
-		}),
-		l,
-	)
-
-const accounts = async () => {
-	const [accounts, domains] = await Promise.all([
-		client.Accounts(),
-		client.Domains(),
-	])
-
-	let fieldset: HTMLFieldSetElement
-	let localpart: HTMLInputElement
-	let domain: HTMLSelectElement
-	let account: HTMLInputElement
-	let accountModified = false
+const auditlog = async () => {
+	const max = 1000
+	const entries = await client.AuditList('', max) || []
 
 	dom._kids(page,
 		crumbs(
 			crumblink('Mox Admin', '#'),
-			'Accounts',
+			'Audit log',
 		),
-		dom.h2('Accounts'),
-		(accounts || []).length === 0 ? dom.p('No accounts') :
-		dom.ul(
-			(accounts || []).map(s => dom.li(dom.a(s, attr.href('#accounts/'+s)))),
+		dom.p('Administrative actions, such as configuration changes, password resets and queue operations, through the admin web interface, the admin API and the mox command-line. Entries are never changed or removed. Passwords are replaced with "***".'),
+		dom.div(
+			dom.clickbutton('Export as JSON', attr.title('Download all entries of the audit log as a JSON file.'), async function click(e: MouseEvent) {
+				const all = await check(e.target! as HTMLButtonElement, client.AuditList('', 0)) || []
+				const blob = new Blob([JSON.stringify(all, undefined, '\t')], {type: 'application/json'})
+				const a = dom.a(attr.href(URL.createObjectURL(blob)), attr.download('mox-audit-log.json'))
+				a.click()
+				URL.revokeObjectURL(a.href)
+			}),
 		),
 		dom.br(),
-		dom.h2('Add account'),
-		dom.form(
-			async function submit(e: SubmitEvent) {
-				e.preventDefault()
-				e.stopPropagation()
-				await check(fieldset, client.AccountAdd(account.value, localpart.value+'@'+domain.value))
-				window.location.hash = '#accounts/'+account.value
-			},
-			fieldset=dom.fieldset(
-				dom.p('Start with the initial email address for the account. The localpart is the account name too by default, but the account name can be changed.'),
-				dom.label(
-					style({display: 'inline-block'}),
-					dom.span('Localpart', attr.title('The part before the "@" of an email address. More addresses, also at different domains, can be added after the account has been created.')),
-					dom.br(),
-					localpart=dom.input(attr.required(''), function keyup() {
-						if (!accountModified) {
-							account.value = localpart.value
-						}
-					}),
-				),
-				'@',
-				dom.label(
-					style({display: 'inline-block'}),
-					dom.span('Domain', attr.title('The domain of the email address, after the "@".')),
-					dom.br(),
-					domain=dom.select(attr.required(''), (domains || []).map(d => dom.option(domainName(d)))),
+		entries.length === max ? dom.p('Showing the most recent ' + max + ' entries, export to see all.') : [],
+		dom.table(dom._class('hover'),
+			dom.thead(
+				dom.tr(
+					dom.th('Time'),
+					dom.th('Source'),
+					dom.th('Actor'),
+					dom.th('Remote IP'),
+					dom.th('Action'),
+					dom.th('Parameters'),
+					dom.th('Error'),
 				),
-				' ',
-				dom.label(
-					style({display: 'inline-block'}),
-					dom.span('Account name', attr.title('An account has a password, and email address(es) (possibly at different domains). Its messages and the message index database are are stored in the file system in a directory with the name of the account. An account name is not an email address. Use a name like a unix user name, or the localpart (the part before the "@") of the initial address.')),
-					dom.br(),
-					account=dom.input(attr.required(''), function change() {
-						accountModified = true
-					}),
+			),
+			dom.tbody(
+				entries.length === 0 ? dom.tr(dom.td(attr.colspan('7'), '(None)')) : [],
+				entries.map(e =>
+					dom.tr(
+						dom.td(e.Time.toISOString(), attr.title(e.Time.toString())),
+						dom.td(e.Source),
+						dom.td(e.Actor),
+						dom.td(e.RemoteIP),
+						dom.td(e.Action),
+						dom.td(style({maxWidth: '40em', wordBreak: 'break-all'}), e.Params),
+						dom.td(e.Error),
+					),
 				),
-				' ',
-				dom.submitbutton('Add account', attr.title('The account will be added and the config reloaded.')),
-			)
-		)
+			),
+		),
+	)
+}
+
+const quarantine = async () => {
+	const messages = await client.Quarantined('') || []
+	const nowSecs = new Date().getTime()/1000
+
+	dom._kids(page,
+		crumbs(
+			crumblink('Mox Admin', '#'),
+			'Quarantine',
+		),
+		dom.p('Incoming messages held in quarantine instead of being rejected, for the reasons configured in mox.conf. Released messages are delivered to the mailbox they were destined for. Messages are removed automatically when they expire.'),
+		dom.table(dom._class('hover'),
+			dom.thead(
+				dom.tr(
+					dom.th('Received'),
+					dom.th('Account'),
+					dom.th('Reason'),
+					dom.th('Remote IP'),
+					dom.th('From'),
+					dom.th('To'),
+					dom.th('Subject'),
+					dom.th('Size'),
+					dom.th('Expires'),
+					dom.th('Action'),
+				),
+			),
+			dom.tbody(
+				messages.length === 0 ? dom.tr(dom.td(attr.colspan('10'), '(None)')) : [],
+				messages.map(qm =>
+					dom.tr(
+						dom.td(age(qm.Received, false, nowSecs)),
+						dom.td(adminScope.LoginAddress ? qm.Account : dom.a(qm.Account, attr.href('#accounts/'+qm.Account))),
+						dom.td(qm.Reason),
+						dom.td(qm.RemoteIP),
+						dom.td(qm.MsgFrom || qm.MailFrom, attr.title('SMTP MAIL FROM: ' + (qm.MailFrom || '<>'))),
+						dom.td(qm.RcptTo),
+						dom.td(qm.Subject),
+						dom.td(formatSize(qm.Size)),
+						dom.td(age(qm.Expires, true, nowSecs)),
+						dom.td(
+							dom.clickbutton('Headers', async function click(e: MouseEvent) {
+								const headers = await check(e.target! as HTMLButtonElement, client.QuarantineHeaders(qm.ID))
+								popup(
+									dom.h1('Headers'),
+									dom.pre(dom._class('literal'), style({maxWidth: '80em', whiteSpace: 'pre-wrap'}), headers),
+								)
+							}), ' ',
+							dom.clickbutton('Release', attr.title('Deliver the message to the mailbox it was destined for.'), async function click(e: MouseEvent) {
+								await check(e.target! as HTMLButtonElement, client.QuarantineRelease(qm.ID))
+								window.location.reload() // todo: reload less
+							}), ' ',
+							dom.clickbutton('Remove', async function click(e: MouseEvent) {
+								if (!window.confirm('Are you sure you want to remove this message?')) {
+									return
+								}
+								await check(e.target! as HTMLButtonElement, client.QuarantineRemove(qm.ID))
+								window.location.reload() // todo: reload less
+							}),
+						),
+					),
+				),
+			),
+		),
+	)
+}
+
+const abusereports = async () => {
+	const reports = await client.AbuseReports(1000) || []
+	const nowSecs = new Date().getTime()/1000
+
+	dom._kids(page,
+		crumbs(
+			crumblink('Mox Admin', '#'),
+			'Abuse reports',
+		),
+		dom.p('Abuse reports (ARF) about messages sent by us, received at the role addresses postmaster@, abuse@ and security@ of the domains, from senders with a validated From address. Reports are matched with sent messages through the delivery history. Recipients of messages with a spam complaint (feedback type "abuse") are added to the suppression list of the sending account, and complaints count as bounces for the submission guard. The reports are also delivered to the postmaster mailbox. Reports are kept for 90 days, at most the 1000 most recent reports are shown.'),
+		dom.table(dom._class('hover'),
+			dom.thead(
+				dom.tr(
+					dom.th('Time'),
+					dom.th('To'),
+					dom.th('Reporter'),
+					dom.th('Type'),
+					dom.th('Message', attr.title('Subject and Message-ID of the reported message.')),
+					dom.th('Account', attr.title('Account that sent the message, if found in the delivery history.')),
+					dom.th('Recipients'),
+					dom.th('Suppressed', attr.title('Recipients added to the suppression list of the account.')),
+					dom.th('Action'),
+				),
+			),
+			dom.tbody(
+				reports.length === 0 ? dom.tr(dom.td(attr.colspan('9'), '(None)')) : [],
+				reports.map(r =>
+					dom.tr(
+						r.Handled ? [] : style({fontWeight: 'bold'}),
+						dom.td(age(r.Time, false, nowSecs)),
+						dom.td(r.Rcpt),
+						dom.td(r.ReporterFrom, r.UserAgent ? attr.title('User agent: '+r.UserAgent) : []),
+						dom.td(r.FeedbackType, r.Incidents > 1 ? ' ('+r.Incidents+'x)' : ''),
+						dom.td(
+							r.Subject,
+							r.MessageID ? [dom.br(), dom.a(r.MessageID, attr.href('#messagetrace/'+encodeURIComponent(r.MessageID)), attr.title('Delivery history of message.'))] : [],
+						),
+						dom.td(r.Account ? dom.a(r.Account, attr.href('#accounts/'+r.Account)) : '-'),
+						dom.td((r.Recipients || []).join(', ') || r.RcptTo),
+						dom.td((r.Suppressed || []).join(', ')),
+						dom.td(
+							dom.clickbutton(r.Handled ? 'Mark unhandled' : 'Mark handled', async function click(e: MouseEvent) {
+								await check(e.target! as HTMLButtonElement, client.AbuseReportSetHandled(r.ID, !r.Handled))
+								window.location.reload() // todo: reload less
+							}),
+						),
+					),
+				),
+			),
+		),
+	)
+}
+
+const spamtraps = async () => {
+	const hits = await client.SpamtrapHits(1000) || []
+	const nowSecs = new Date().getTime()/1000
+
+	dom._kids(page,
+		crumbs(
+			crumblink('Mox Admin', '#'),
+			'Spamtrap hits',
+		),
+		dom.p('Messages sent to spamtrap addresses, configured per domain. These messages are not delivered. The shared junk filter is trained with them, and for 30 days, messages from the same remote network or validated sender domain are rejected. At most the 1000 most recent hits are shown.'),
+		dom.table(dom._class('hover'),
+			dom.thead(
+				dom.tr(
+					dom.th('Time'),
+					dom.th('Spamtrap'),
+					dom.th('Remote IP'),
+					dom.th('EHLO'),
+					dom.th('Sender domain', attr.title('Organizational domain of the sender, if validated through DMARC or SPF.')),
+					dom.th('From'),
+					dom.th('Subject'),
+					dom.th('Size'),
+				),
+			),
+			dom.tbody(
+				hits.length === 0 ? dom.tr(dom.td(attr.colspan('8'), '(None)')) : [],
+				hits.map(h =>
+					dom.tr(
+						dom.td(age(h.Time, false, nowSecs)),
+						dom.td(h.Trap),
+						dom.td(h.RemoteIP, attr.title('Network: ' + h.RemoteNetwork)),
+						dom.td(h.EHLO),
+						dom.td(h.Domain),
+						dom.td(h.MsgFrom || h.MailFrom, attr.title('SMTP MAIL FROM: ' + (h.MailFrom || '<>'))),
+						dom.td(h.Subject),
+						dom.td(formatSize(h.Size)),
+					),
+				),
+			),
+		),
+	)
+}
+
+const lineendings = async () => {
+	const sources = await client.LineEndingSources(1000) || []
+	const nowSecs = new Date().getTime()/1000
+
+	dom._kids(page,
+		crumbs(
+			crumblink('Mox Admin', '#'),
+			'Line ending anomalies',
+		),
+		dom.p('Remote IPs that sent messages with bare carriage returns or newlines, or with a dot on a line ending in something other than CRLF, in the SMTP message data. Such messages can be used for SMTP smuggling. Whether messages with anomalies are rejected or only logged is configured with SMTPLineEndings in mox.conf. Use the counts below to see which senders would be affected before rejecting. Sources are removed 30 days after their last message. At most the 1000 most recent sources are shown.'),
+		dom.table(dom._class('hover'),
+			dom.thead(
+				dom.tr(
+					dom.th('Last'),
+					dom.th('First'),
+					dom.th('Remote IP'),
+					dom.th('EHLO', attr.title('Of the most recent message.')),
+					dom.th('MAIL FROM domain', attr.title('Of the most recent message.')),
+					dom.th('Submission', attr.title('Whether the most recent message was submitted by an authenticated account.')),
+					dom.th('Messages'),
+					dom.th('Bare CR'),
+					dom.th('Bare LF'),
+					dom.th('Bad data end', attr.title('Dot on a line with other line endings than CRLF.')),
+					dom.th('Rejected'),
+				),
+			),
+			dom.tbody(
+				sources.length === 0 ? dom.tr(dom.td(attr.colspan('11'), '(None)')) : [],
+				sources.map(s =>
+					dom.tr(
+						dom.td(age(s.Last, false, nowSecs)),
+						dom.td(age(s.First, false, nowSecs)),
+						dom.td(s.RemoteIP),
+						dom.td(s.EHLO),
+						dom.td(s.MailFromDomain || '<>'),
+						dom.td(s.Submission ? 'Yes' : 'No'),
+						dom.td(style({textAlign: 'right'}), ''+s.Messages),
+						dom.td(style({textAlign: 'right'}), ''+s.BareCR),
+						dom.td(style({textAlign: 'right'}), ''+s.BareLF),
+						dom.td(style({textAlign: 'right'}), ''+s.BadDataEnd),
+						dom.td(style({textAlign: 'right'}), ''+s.Rejected),
+					),
+				),
+			),
+		),
+	)
+}
+
+const autobans = async () => {
+	const bans = await client.AutoBans() || []
+	const nowSecs = new Date().getTime()/1000
+
+	dom._kids(page,
+		crumbs(
+			crumblink('Mox Admin', '#'),
+			'Automatic bans',
+		),
+		dom.p('Networks automatically banned after many failed authentication attempts or protocol errors, such as unknown commands, within a time window. New connections and logins from banned networks are refused until the ban ends. Bans are kept in memory only, and are lost on restart. Automatic bans are configured with AutoBan in mox.conf.'),
+		dom.table(dom._class('hover'),
+			dom.thead(
+				dom.tr(
+					dom.th('Network'),
+					dom.th('Reason'),
+					dom.th('Failures'),
+					dom.th('Start'),
+					dom.th('End'),
+					dom.th('Refused', attr.title('Connections and logins refused during the ban.')),
+					dom.th('Action'),
+				),
+			),
+			dom.tbody(
+				bans.length === 0 ? dom.tr(dom.td(attr.colspan('7'), '(None)')) : [],
+				bans.map(b =>
+					dom.tr(
+						dom.td(b.Network),
+						dom.td(b.Reason),
+						dom.td(style({textAlign: 'right'}), ''+b.Failures),
+						dom.td(age(b.Start, false, nowSecs)),
+						dom.td(age(b.End, true, nowSecs)),
+						dom.td(style({textAlign: 'right'}), ''+b.Refused),
+						dom.td(
+							dom.clickbutton('Remove', attr.title('Remove the ban, allowing connections from the network again.'), async function click(e: MouseEvent) {
+								await check(e.target! as HTMLButtonElement, client.AutoBanRemove(b.Network))
+								window.location.reload() // todo: only reload the list
+							}),
+						),
+					),
+				),
+			),
+		),
+	)
+}
+
+const loglevels = async () => {
+	const loglevels = await client.LogLevels()
+
+	const levels = ['error', 'info', 'warn', 'debug', 'trace', 'traceauth', 'tracedata']
+
+	let form: HTMLFormElement
+	let fieldset: HTMLFieldSetElement
+	let pkg: HTMLInputElement
+	let level: HTMLSelectElement
+
+	dom._kids(page,
+		crumbs(
+			crumblink('Mox Admin', '#'),
+			'Log levels',
+		),
+		dom.p('Note: changing a log level here only changes it for the current process. When mox restarts, or receives a SIGHUP signal, it sets the log levels from the configuration file. Change mox.conf to keep the changes.'),
+		dom.div(
+			dom.clickbutton('Reset to config file', attr.title('Set the log levels from mox.conf, undoing changes made here.'), async function click(e: MouseEvent) {
+				e.preventDefault()
+				await check(e.target! as HTMLButtonElement, client.LogLevelsReset())
+				window.location.reload() // todo: reload just the current loglevels
+			}),
+		),
+		dom.br(),
+		dom.table(
+			dom.thead(
+				dom.tr(
+					dom.th('Package', attr.title('Log levels can be configured per package. E.g. smtpserver, imapserver, dkim, dmarc, tlsrpt, etc.')),
+					dom.th('Level', attr.title('If you set the log level to "trace", imap and smtp protocol transcripts will be logged. Sensitive authentication is replaced with "***" unless the level is >= "traceauth". Data is masked with "..." unless the level is "tracedata".')),
+					dom.th('Action'),
+				),
+			),
+			dom.tbody(
+				Object.entries(loglevels).map(t => {
+					let lvl: HTMLSelectElement
+					return dom.tr(
+						dom.td(t[0] || '(default)'),
+						dom.td(
+							lvl=dom.select(levels.map(l => dom.option(l, t[1] === l ? attr.selected('') : []))),
+						),
+						dom.td(
+							dom.clickbutton('Save', attr.title('Set new log level for package.'), async function click(e: MouseEvent) {
+								e.preventDefault()
+								await check(e.target! as HTMLButtonElement, client.LogLevelSet(t[0], lvl.value))
+								window.location.reload() // todo: reload just the current loglevels
+							}),
+							' ',
+							dom.clickbutton('Remove', attr.title('Remove this log level, the default log level will apply.'), t[0] === '' ? attr.disabled('') : [], async function click(e: MouseEvent) {
+								e.preventDefault()
+								await check(e.target! as HTMLButtonElement, client.LogLevelRemove(t[0]))
+								window.location.reload() // todo: reload just the current loglevels
+							}),
+						),
+					)
+				}),
+			),
+		),
+		dom.br(),
+		dom.h2('Add log level setting'),
+		form=dom.form(
+			async function submit(e: SubmitEvent) {
+				e.preventDefault()
+				e.stopPropagation()
+				await check(fieldset, client.LogLevelSet(pkg.value, level.value))
+				form.reset()
+				window.location.reload() // todo: reload just the current loglevels
+			},
+			fieldset=dom.fieldset(
+				dom.label(
+					style({display: 'inline-block'}),
+					'Package',
+					dom.br(),
+					pkg=dom.input(attr.required('')),
+				),
+				' ',
+				dom.label(
+					style({display: 'inline-block'}),
+					'Level',
+					dom.br(),
+					level=dom.select(
+						attr.required(''),
+						levels.map(l => dom.option(l, l === 'debug' ? attr.selected('') : [])),
+					),
+				),
+				' ',
+				dom.submitbutton('Add'),
+			),
+			dom.br(),
+			dom.p('Suggestions for packages: autotls dkim dmarc dmarcdb dns dnsbl dsn http imapserver iprev junk message metrics mox moxio mtasts mtastsdb publicsuffix queue sendmail serve smtpserver spf store subjectpass tlsrpt tlsrptdb updates'),
+		),
+	)
+}
+
+const logs = async () => {
+	const levels = ['', 'error', 'info', 'warn', 'debug', 'trace', 'traceauth', 'tracedata']
+
+	let fieldset: HTMLFieldSetElement
+	let account: HTMLInputElement
+	let messageID: HTMLInputElement
+	let cid: HTMLInputElement
+	let pkg: HTMLInputElement
+	let level: HTMLSelectElement
+	let text: HTMLInputElement
+	let max: HTMLInputElement
+	let results: HTMLElement
+
+	const render = (entries: api.LogEntry[], n: number) => {
+		dom._kids(results,
+			entries.length === n ? dom.p('Showing the most recent ' + n + ' matching entries.') : [],
+			dom.table(dom._class('hover'),
+				dom.thead(
+					dom.tr(
+						dom.th('Time'),
+						dom.th('Level'),
+						dom.th('Package'),
+						dom.th('Message'),
+						dom.th('Attributes'),
+					),
+				),
+				dom.tbody(
+					entries.length === 0 ? dom.tr(dom.td(attr.colspan('5'), '(None)')) : [],
+					entries.map(e =>
+						dom.tr(
+							dom.td(e.Time.toISOString(), attr.title(e.Time.toString())),
+							dom.td(e.Level),
+							dom.td(e.Pkg),
+							dom.td(e.Message),
+							dom.td(style({maxWidth: '60em', wordBreak: 'break-all'}), (e.Fields || []).map(f => f.Key + '=' + f.Value).join(' ')),
+						),
+					),
+				),
+			),
+		)
+	}
+
+	dom._kids(page,
+		crumbs(
+			crumblink('Mox Admin', '#'),
+			'Recent log',
+		),
+		dom.p('Recent log entries, kept in memory by the running mox process, most recent first. Only entries at the configured log levels are kept, see ', dom.a('Log levels', attr.href('#loglevels')), '. The number of entries kept is configured with LogRecent in mox.conf.'),
+		dom.form(
+			async function submit(e: SubmitEvent) {
+				e.preventDefault()
+				e.stopPropagation()
+				const filter: api.LogFilter = {
+					Account: account.value,
+					MessageID: messageID.value,
+					Cid: cid.value,
+					Pkg: pkg.value,
+					Level: level.value,
+					Text: text.value,
+					Max: parseInt(max.value) || 0,
+				}
+				const entries = await check(fieldset, client.LogRecent(filter)) || []
+				render(entries, filter.Max || 1000)
+			},
+			fieldset=dom.fieldset(
+				dom.label(
+					style({display: 'inline-block'}),
+					'Account',
+					dom.br(),
+					account=dom.input(),
+				),
+				' ',
+				dom.label(
+					style({display: 'inline-block'}),
+					'Message-ID',
+					dom.br(),
+					messageID=dom.input(),
+				),
+				' ',
+				dom.label(
+					style({display: 'inline-block'}),
+					'Connection ID',
+					attr.title('The "cid" attribute in log entries, in hex. Use "Lookup cid" on the main page to find the cid for the ID in a Received header.'),
+					dom.br(),
+					cid=dom.input(),
+				),
+				' ',
+				dom.label(
+					style({display: 'inline-block'}),
+					'Package',
+					dom.br(),
+					pkg=dom.input(),
+				),
+				' ',
+				dom.label(
+					style({display: 'inline-block'}),
+					'Minimum level',
+					dom.br(),
+					level=dom.select(levels.map(l => dom.option(l || '(any)', attr.value(l)))),
+				),
+				' ',
+				dom.label(
+					style({display: 'inline-block'}),
+					'Text',
+					attr.title('Case-insensitive text in the message or attribute values.'),
+					dom.br(),
+					text=dom.input(),
+				),
+				' ',
+				dom.label(
+					style({display: 'inline-block'}),
+					'Max',
+					dom.br(),
+					max=dom.input(attr.type('number'), attr.min('1'), attr.value('1000'), style({width: '6em'})),
+				),
+				' ',
+				dom.submitbutton('Search'),
+			),
+		),
+		dom.br(),
+		results=dom.div(),
+	)
+
+	const entries = await client.LogRecent({Account: '', MessageID: '', Cid: '', Pkg: '', Level: '', Text: '', Max: 1000}) || []
+	render(entries, 1000)
+}
+
+const messagetrace = async (id: string) => {
+	const events = id ? await client.MessageTrace(id) || [] : []
+
+	let idElem: HTMLInputElement
+
+	dom._kids(page,
+		crumbs(
+			crumblink('Mox Admin', '#'),
+			'Message trace',
+		),
+		dom.p('Delivery history of a message: when it was received, rulesets that matched, the junk filter classification, whether it was delivered, rejected or queued, and each delivery attempt from the queue with the response from the remote server. Events are kept for 30 days.'),
+		dom.form(
+			function submit(e: SubmitEvent) {
+				e.preventDefault()
+				e.stopPropagation()
+				window.location.hash = '#messagetrace/' + encodeURIComponent(idElem.value.trim())
+			},
+			dom.fieldset(
+				dom.label(
+					style({display: 'inline-block'}),
+					'Message-ID or queue message ID',
+					dom.br(),
+					idElem=dom.input(attr.required(''), attr.value(id), style({width: '40em'})),
+				),
+				' ',
+				dom.submitbutton('Trace'),
+			),
+		),
+		dom.br(),
+		id ? dom.table(dom._class('hover'),
+			dom.thead(
+				dom.tr(
+					dom.th('Time'),
+					dom.th('Event'),
+					dom.th('Queue ID'),
+					dom.th('Account'),
+					dom.th('Recipient'),
+					dom.th('Remote'),
+					dom.th('Result'),
+					dom.th('Detail'),
+					dom.th('Cid', attr.title('Connection ID, as in the "cid" attribute in logging.')),
+				),
+			),
+			dom.tbody(
+				events.length === 0 ? dom.tr(dom.td(attr.colspan('9'), 'No events found.')) : [],
+				events.map(e =>
+					dom.tr(
+						dom.td(e.Time.toISOString(), attr.title(e.Time.toString())),
+						dom.td(e.Kind),
+						dom.td(e.QueueID ? ''+e.QueueID : ''),
+						dom.td(e.Account),
+						dom.td(e.Recipient),
+						dom.td(e.Remote),
+						dom.td(e.Result),
+						dom.td(style({maxWidth: '50em', wordBreak: 'break-word'}), e.Detail),
+						dom.td(e.Cid ? e.Cid.toString(16) : ''),
+					),
+				),
+			),
+		) : [],
+	)
+}
+
+const usage = async () => {
+	const month = new Date().toISOString().substring(0, 7)
+
+	let fieldset: HTMLFieldSetElement
+	let start: HTMLInputElement
+	let end: HTMLInputElement
+	let account: HTMLInputElement
+	let domain: HTMLInputElement
+	let group: HTMLSelectElement
+	let results: HTMLElement
+	let last: api.Usage[] = []
+
+	const csv = (l: api.Usage[]) => {
+		const fields = ['month', 'account', 'domain', 'messages_received', 'bytes_received', 'messages_sent', 'bytes_sent', 'stored_bytes']
+		const quote = (s: string) => /[",\r\n]/.test(s) ? '"' + s.replace(/"/g, '""') + '"' : s
+		const lines = [fields.join(',')].concat(l.map(u => [quote(u.Month), quote(u.Account), quote(u.Domain), u.MessagesReceived, u.BytesReceived, u.MessagesSent, u.BytesSent, u.StoredBytes].join(',')))
+		return lines.join('\r\n') + '\r\n'
+	}
+
+	const search = async () => {
+		const l = await check(fieldset, client.UsageList(start.value, end.value, account.value.trim(), domain.value.trim(), group.value)) || []
+		last = l
+		dom._kids(results,
+			dom.table(dom._class('hover'),
+				dom.thead(
+					dom.tr(
+						dom.th('Month'),
+						dom.th('Account'),
+						dom.th('Domain'),
+						dom.th('Received', attr.title('Incoming messages delivered to the account, per recipient.')),
+						dom.th('Received size'),
+						dom.th('Sent', attr.title('Outgoing messages delivered to remote mail servers, per recipient.')),
+						dom.th('Sent size'),
+						dom.th('Stored size', attr.title('Highest sampled total size of messages in the account during the month.')),
+					),
+				),
+				dom.tbody(
+					l.length === 0 ? dom.tr(dom.td(attr.colspan('8'), '(None)')) : [],
+					l.map(u =>
+						dom.tr(
+							dom.td(u.Month),
+							dom.td(u.Account ? dom.a(u.Account, attr.href('#accounts/' + encodeURIComponent(u.Account))) : ''),
+							dom.td(u.Domain),
+							dom.td(style({textAlign: 'right'}), ''+u.MessagesReceived),
+							dom.td(style({textAlign: 'right'}), formatSize(u.BytesReceived)),
+							dom.td(style({textAlign: 'right'}), ''+u.MessagesSent),
+							dom.td(style({textAlign: 'right'}), formatSize(u.BytesSent)),
+							dom.td(style({textAlign: 'right'}), formatSize(u.StoredBytes)),
+						),
+					),
+				),
+			),
+		)
+	}
+
+	dom._kids(page,
+		crumbs(
+			crumblink('Mox Admin', '#'),
+			'Usage',
+		),
+		dom.p('Usage per calendar month (UTC), per account and domain, for reporting and billing: incoming messages delivered to accounts, outgoing messages delivered to remote mail servers, and the highest total size of stored messages. Also available with "mox usage" and through the admin API.'),
+		dom.form(
+			async function submit(e: SubmitEvent) {
+				e.preventDefault()
+				e.stopPropagation()
+				await search()
+			},
+			fieldset=dom.fieldset(
+				dom.label(
+					style({display: 'inline-block'}),
+					'Start month',
+					dom.br(),
+					start=dom.input(attr.type('month'), attr.value(month)),
+				),
+				' ',
+				dom.label(
+					style({display: 'inline-block'}),
+					'End month',
+					dom.br(),
+					end=dom.input(attr.type('month'), attr.value(month)),
+				),
+				' ',
+				dom.label(
+					style({display: 'inline-block'}),
+					'Account',
+					dom.br(),
+					account=dom.input(),
+				),
+				' ',
+				dom.label(
+					style({display: 'inline-block'}),
+					'Domain',
+					dom.br(),
+					domain=dom.input(),
+				),
+				' ',
+				dom.label(
+					style({display: 'inline-block'}),
+					'Group',
+					dom.br(),
+					group=dom.select(
+						dom.option('Per account and domain', attr.value('')),
+						dom.option('Per account', attr.value('account')),
+						dom.option('Per domain', attr.value('domain')),
+					),
+				),
+				' ',
+				dom.submitbutton('Show'),
+				' ',
+				dom.clickbutton('Export as CSV', attr.title('Download the usage shown as a CSV file.'), function click() {
+					const blob = new Blob([csv(last)], {type: 'text/csv'})
+					const a = dom.a(attr.href(URL.createObjectURL(blob)), attr.download('mox-usage.csv'))
+					a.click()
+					URL.revokeObjectURL(a.href)
+				}),
+			),
+		),
+		dom.br(),
+		results=dom.div(),
+	)
+	await search()
+}
+
+const box = (color: string, ...l: ElemArg[]) => [
+	dom.div(
+		style({
+			display: 'inline-block',
+			padding: '.25em .5em',
+			backgroundColor: color,
+			borderRadius: '3px',
+			margin: '.5ex 0',
+		}),
+		l,
+	),
+	dom.br(),
+]
+const inlineBox = (color: string, ...l: ElemArg[]) =>
+	dom.span(
+		style({
+			display: 'inline-block',
+			padding: color ? '0.05em 0.2em' : '',
+			backgroundColor: color,
+			borderRadius: '3px',
+		}),
+		l,
+	)
+
+const accounts = async () => {
+	const [accounts, domains] = await Promise.all([
+		client.Accounts(),
+		client.Domains(),
+	])
+
+	let fieldset: HTMLFieldSetElement
+	let localpart: HTMLInputElement
+	let domain: HTMLSelectElement
+	let account: HTMLInputElement
+	let accountModified = false
+
+	dom._kids(page,
+		crumbs(
+			crumblink('Mox Admin', '#'),
+			'Accounts',
+		),
+		dom.h2('Accounts'),
+		(accounts || []).length === 0 ? dom.p('No accounts') :
+		dom.ul(
+			(accounts || []).map(s => dom.li(dom.a(s, attr.href('#accounts/'+s)))),
+		),
+		dom.br(),
+		dom.h2('Add account'),
+		dom.form(
+			async function submit(e: SubmitEvent) {
+				e.preventDefault()
+				e.stopPropagation()
+				await check(fieldset, client.AccountAdd(account.value, localpart.value+'@'+domain.value))
+				window.location.hash = '#accounts/'+account.value
+			},
+			fieldset=dom.fieldset(
+				dom.p('Start with the initial email address for the account. The localpart is the account name too by default, but the account name can be changed.'),
+				dom.label(
+					style({display: 'inline-block'}),
+					dom.span('Localpart', attr.title('The part before the "@" of an email address. More addresses, also at different domains, can be added after the account has been created.')),
+					dom.br(),
+					localpart=dom.input(attr.required(''), function keyup() {
+						if (!accountModified) {
+							account.value = localpart.value
+						}
+					}),
+				),
+				'@',
+				dom.label(
+					style({display: 'inline-block'}),
+					dom.span('Domain', attr.title('The domain of the email address, after the "@".')),
+					dom.br(),
+					domain=dom.select(attr.required(''), (domains || []).map(d => dom.option(domainName(d)))),
+				),
+				' ',
+				dom.label(
+					style({display: 'inline-block'}),
+					dom.span('Account name', attr.title('An account has a password, and email address(es) (possibly at different domains). Its messages and the message index database are are stored in the file system in a directory with the name of the account. An account name is not an email address. Use a name like a unix user name, or the localpart (the part before the "@") of the initial address.')),
+					dom.br(),
+					account=dom.input(attr.required(''), function change() {
+						accountModified = true
+					}),
+				),
+				' ',
+				dom.submitbutton('Add account', attr.title('The account will be added and the config reloaded.')),
+			)
+		)
 	)
 }
 
@@ -755,12 +1782,157 @@ const RoutesEditor = (kind: string, transports: { [key: string]: api.Transport }
 	return render()
 }
 
+const FooterEditor = (kind: string, footer: api.Footer, save: (footer: api.Footer | null) => Promise<void>) => {
+	let fieldset: HTMLFieldSetElement
+	let text: HTMLTextAreaElement
+	let html: HTMLTextAreaElement
+	let skipReplies: HTMLInputElement
+
+	// Empty lines within the footer are kept, leading and trailing empty lines are not.
+	const lines = (s: string) => s.trim() ? s.replace(/^\s*\n|\n\s*$/g, '').split('\n').map(s => s.trimEnd()) : []
+
+	return [
+		dom.h2('Footer', attr.title('Footer, e.g. a legal disclaimer, added to the text of messages submitted by '+kind+'. It is added to text/plain and text/html parts, not to attachments. Signed and encrypted messages are not changed. A footer configured for an account takes precedence over a footer configured for the domain of the message From address.')),
+		dom.form(
+			async function submit(e: SubmitEvent) {
+				e.preventDefault()
+				e.stopPropagation()
+				const f: api.Footer = {Text: lines(text.value), HTML: lines(html.value), SkipReplies: skipReplies.checked}
+				const nf = (f.Text || []).length === 0 && (f.HTML || []).length === 0 ? null : f
+				await check(fieldset, save(nf))
+			},
+			fieldset=dom.fieldset(
+				style({display: 'flex', gap: '1em'}),
+				dom.label(
+					dom.div('Text', attr.title('Added after an empty line at the end of text/plain parts. If empty, text/plain parts are not changed.')),
+					text=dom.textarea((footer.Text || []).join('\n'), attr.rows('4'), style({width: '30em'})),
+				),
+				dom.label(
+					dom.div('HTML', attr.title('Inserted before the closing body tag of text/html parts. If empty, the text footer is used, escaped for HTML.')),
+					html=dom.textarea((footer.HTML || []).join('\n'), attr.rows('4'), style({width: '30em'})),
+				),
+				dom.div(
+					dom.label(
+						skipReplies=dom.input(attr.type('checkbox'), footer.SkipReplies ? attr.checked('') : []),
+						' Skip replies',
+						attr.title('Do not add the footer to replies, i.e. messages with an In-Reply-To or References header. Earlier messages in the thread typically already have the footer.'),
+					),
+					dom.br(),
+					dom.submitbutton('Save', attr.title('Save footer. With empty text and HTML, the footer is removed.')),
+				),
+			),
+		),
+	]
+}
+
+const AddressRewritesEditor = (kind: string, rules: api.AddressRewrite[], save: (rules: api.AddressRewrite[]) => Promise<void>) => {
+	const hdr = dom.h2('Address rewrites', attr.title('Rules for rewriting email addresses, e.g. to map a legacy domain onto a new domain during a migration, or to masquerade addresses of an internal domain. Recipient rules are applied to incoming messages and submitted messages, sender rules to the SMTP MAIL FROM and message From addresses of submitted messages. For an address, the rules of its domain and the global rules are evaluated by descending priority, the first match is applied.'))
+
+	let rewritesElem: HTMLElement
+	const render = () => {
+		let rewritesFieldset: HTMLFieldSetElement
+		interface RewriteRow {
+			root: HTMLElement
+			gather: () => api.AddressRewrite
+		}
+		let rewriteRows: RewriteRow[] = []
+
+		const add = () => {
+			rules = rewriteRows.map(rr => rr.gather())
+			rules.push({AddressRegexp: '', Replace: '', Priority: 0, Sender: false, Recipient: true})
+			render()
+		}
+
+		let elem: HTMLElement = dom.form(
+			async function submit(e: SubmitEvent) {
+				e.stopPropagation()
+				e.preventDefault()
+				await check(rewritesFieldset, save(rewriteRows.map(rr => rr.gather())))
+			},
+			rewritesFieldset=dom.fieldset(
+				dom.table(
+					dom.thead(
+						dom.tr(
+							dom.th('Address regexp', attr.title('Regular expression matched against the full email address, case-insensitive, e.g. ^(.+)@internal\\.example$.')),
+							dom.th('Replace', attr.title('Replacement address, with $1 for the first submatch, etc., e.g. $1@example.org.')),
+							dom.th('Priority', attr.title('Rules with a higher priority are evaluated first.')),
+							dom.th('Sender', attr.title('Rewrite sender addresses of submitted messages. The rewritten address must be in a configured domain.')),
+							dom.th('Recipient', attr.title('Rewrite recipient addresses of incoming and submitted messages.')),
+							dom.th(dom.clickbutton('Add', function click() { add() })),
+						),
+					),
+					dom.tbody(
+						(rules || []).length === 0 ? dom.tr(dom.td(attr.colspan('6'), 'No address rewrites.')) : [],
+						rewriteRows=(rules || []).map((r, index) => {
+							let addressRegexp = dom.input(attr.value(r.AddressRegexp), attr.required(''))
+							let replace = dom.input(attr.value(r.Replace), attr.required(''))
+							let priority = dom.input(attr.type('number'), attr.value(''+r.Priority), style({width: '5em'}))
+							let sender = dom.input(attr.type('checkbox'), r.Sender ? attr.checked('') : [])
+							let recipient = dom.input(attr.type('checkbox'), r.Recipient ? attr.checked('') : [])
+
+							const tr = dom.tr(
+								dom.td(addressRegexp),
+								dom.td(replace),
+								dom.td(priority),
+								dom.td(sender),
+								dom.td(recipient),
+								dom.td(
+									dom.clickbutton('Remove', function click() {
+										rewriteRows.splice(index, 1)
+										rules = rewriteRows.map(rr => rr.gather())
+										render()
+									}),
+								),
+							)
+							return {
+								root: tr,
+								gather: (): api.AddressRewrite => {
+									return {
+										AddressRegexp: addressRegexp.value,
+										Replace: replace.value,
+										Priority: parseInt(priority.value) || 0,
+										Sender: sender.checked,
+										Recipient: recipient.checked,
+									}
+								},
+							}
+						}),
+					),
+				),
+				dom.div(dom.submitbutton('Save')),
+			),
+		)
+		if (!rewritesElem && (rules || []).length === 0) {
+			// Keep it short.
+			elem = dom.div(
+				'No '+kind+' address rewrites configured. ',
+				dom.clickbutton('Add', function click() { add() }),
+			)
+		}
+		elem = dom.div(hdr, elem)
+		if (rewritesElem) {
+			rewritesElem.replaceWith(elem)
+		}
+		rewritesElem = elem
+		return elem
+	}
+	return render()
+}
+
 const account = async (name: string) => {
-	const [[config, diskUsage], domains, transports] = await Promise.all([
+	const [[config, diskUsage], domains, transports, sessions, passkeys, deletions, incidents, passwordChangeRequired] = await Promise.all([
 		client.Account(name),
 		client.Domains(),
 		client.Transports(),
+		client.AccountProtocolSessions(name),
+		client.AccountPasskeys(name),
+		client.AccountDeletions(),
+		client.SubmissionIncidents(name, 20),
+		client.AccountPasswordChangeRequired(name),
 	])
+	const deletion = (deletions || []).find(d => d.Account === name)
+	const nowSecs = new Date().getTime()/1000
+	const throttled = (incidents || []).some(si => si.Action === 'throttle' && !si.Cleared && si.Until.getTime()/1000 > nowSecs)
 
 	// todo: show suppression list, and buttons to add/remove entries.
 
@@ -772,9 +1944,14 @@ const account = async (name: string) => {
 	let fieldsetSettings: HTMLFieldSetElement
 	let maxOutgoingMessagesPerDay: HTMLInputElement
 	let maxFirstTimeRecipientsPerDay: HTMLInputElement
+	let maxAliases: HTMLInputElement
 	let quotaMessageSize: HTMLInputElement
 	let firstTimeSenderDelay: HTMLInputElement
 
+	let fieldsetJunkShared: HTMLFieldSetElement
+	let junkContributeShared: HTMLInputElement
+	let junkSharedWeight: HTMLInputElement
+
 	let formPassword: HTMLFormElement
 	let fieldsetPassword: HTMLFieldSetElement
 	let password: HTMLInputElement
@@ -909,44 +2086,79 @@ const account = async (name: string) => {
 		),
 		dom.br(),
 
-		dom.h2('Settings'),
-		dom.form(
-			fieldsetSettings=dom.fieldset(
-				dom.label(
-					style({display: 'block', marginBottom: '.5ex'}),
-					dom.span('Maximum outgoing messages per day', attr.title('Maximum number of outgoing messages for this account in a 24 hour window. This limits the damage to recipients and the reputation of this mail server in case of account compromise. Default 1000. MaxOutgoingMessagesPerDay in configuration file.')),
-					dom.br(),
-					maxOutgoingMessagesPerDay=dom.input(attr.type('number'), attr.required(''), attr.value(''+(config.MaxOutgoingMessagesPerDay || 1000))),
-				),
-				dom.label(
-					style({display: 'block', marginBottom: '.5ex'}),
-					dom.span('Maximum first-time recipients per day', attr.title('Maximum number of first-time recipients in outgoing messages for this account in a 24 hour window. This limits the damage to recipients and the reputation of this mail server in case of account compromise. Default 200. MaxFirstTimeRecipientsPerDay in configuration file.')),
-					dom.br(),
-					maxFirstTimeRecipientsPerDay=dom.input(attr.type('number'), attr.required(''), attr.value(''+(config.MaxFirstTimeRecipientsPerDay || 200))),
-				),
-				dom.label(
-					style({display: 'block', marginBottom: '.5ex'}),
-					dom.span('Disk usage quota: Maximum total message size ', attr.title('Default maximum total message size in bytes for the account, overriding any globally configured default maximum size if non-zero. A negative value can be used to have no limit in case there is a limit by default. Attempting to add new messages to an account beyond its maximum total size will result in an error. Useful to prevent a single account from filling storage.')),
-					dom.br(),
-					quotaMessageSize=dom.input(attr.value(formatQuotaSize(config.QuotaMessageSize))),
-					' Current usage is ', formatQuotaSize(Math.floor(diskUsage/(1024*1024))*1024*1024), '.',
+		adminScope.LoginAddress ? [] : [
+			dom.h2('Settings'),
+			dom.form(
+				fieldsetSettings=dom.fieldset(
+					dom.label(
+						style({display: 'block', marginBottom: '.5ex'}),
+						dom.span('Maximum outgoing messages per day', attr.title('Maximum number of outgoing messages for this account in a 24 hour window. This limits the damage to recipients and the reputation of this mail server in case of account compromise. Default 1000. MaxOutgoingMessagesPerDay in configuration file.')),
+						dom.br(),
+						maxOutgoingMessagesPerDay=dom.input(attr.type('number'), attr.required(''), attr.value(''+(config.MaxOutgoingMessagesPerDay || 1000))),
+					),
+					dom.label(
+						style({display: 'block', marginBottom: '.5ex'}),
+						dom.span('Maximum first-time recipients per day', attr.title('Maximum number of first-time recipients in outgoing messages for this account in a 24 hour window. This limits the damage to recipients and the reputation of this mail server in case of account compromise. Default 200. MaxFirstTimeRecipientsPerDay in configuration file.')),
+						dom.br(),
+						maxFirstTimeRecipientsPerDay=dom.input(attr.type('number'), attr.required(''), attr.value(''+(config.MaxFirstTimeRecipientsPerDay || 200))),
+					),
+					dom.label(
+						style({display: 'block', marginBottom: '.5ex'}),
+						dom.span('Maximum self-service aliases', attr.title('Maximum number of aliases the account can create itself through the account web interface, in domains of its addresses and with its own addresses as members. Default 0, account can not create aliases. MaxAliases in configuration file.')),
+						dom.br(),
+						maxAliases=dom.input(attr.type('number'), attr.min('0'), attr.required(''), attr.value(''+(config.MaxAliases || 0))),
+					),
+					dom.label(
+						style({display: 'block', marginBottom: '.5ex'}),
+						dom.span('Disk usage quota: Maximum total message size ', attr.title('Default maximum total message size in bytes for the account, overriding any globally configured default maximum size if non-zero. A negative value can be used to have no limit in case there is a limit by default. Attempting to add new messages to an account beyond its maximum total size will result in an error. Useful to prevent a single account from filling storage.')),
+						dom.br(),
+						quotaMessageSize=dom.input(attr.value(formatQuotaSize(config.QuotaMessageSize))),
+						' Current usage is ', formatQuotaSize(Math.floor(diskUsage/(1024*1024))*1024*1024), '.',
+					),
+					dom.div(
+						style({display: 'block', marginBottom: '.5ex'}),
+						dom.label(
+							firstTimeSenderDelay=dom.input(attr.type('checkbox'), config.NoFirstTimeSenderDelay ? [] : attr.checked('')), ' ',
+							dom.span('Delay deliveries from first-time senders.', attr.title('To slow down potential spammers, when the message is misclassified as non-junk. Turning off the delay can be useful when the account processes messages automatically and needs fast responses.')),
+						),
+					),
+					dom.submitbutton('Save'),
 				),
-				dom.div(
-					style({display: 'block', marginBottom: '.5ex'}),
+				async function submit(e: SubmitEvent) {
+					e.stopPropagation()
+					e.preventDefault()
+					await check(fieldsetSettings, client.AccountSettingsSave(name, parseInt(maxOutgoingMessagesPerDay.value) || 0, parseInt(maxFirstTimeRecipientsPerDay.value) || 0, parseInt(maxAliases.value) || 0, xparseSize(quotaMessageSize.value), firstTimeSenderDelay.checked))
+				},
+			),
+			dom.br(),
+		],
+		adminScope.LoginAddress || !config.JunkFilter ? [] : [
+			dom.h2('Shared junk filter', attr.title('The shared junk filter is trained with messages from all accounts that contribute to it. It helps classify messages for accounts without much training history, such as new accounts.')),
+			dom.form(
+				fieldsetJunkShared=dom.fieldset(
+					dom.div(
+						style({display: 'block', marginBottom: '.5ex'}),
+						dom.label(
+							junkContributeShared=dom.input(attr.type('checkbox'), config.JunkFilter.ContributeShared ? attr.checked('') : []), ' ',
+							dom.span('Contribute to shared junk filter', attr.title('Also train the shared junk filter with messages of this account that are marked as junk or nonjunk. Only enable for accounts whose junk/nonjunk classifications can be trusted. ContributeShared in configuration file.')),
+						),
+					),
 					dom.label(
-						firstTimeSenderDelay=dom.input(attr.type('checkbox'), config.NoFirstTimeSenderDelay ? [] : attr.checked('')), ' ',
-						dom.span('Delay deliveries from first-time senders.', attr.title('To slow down potential spammers, when the message is misclassified as non-junk. Turning off the delay can be useful when the account processes messages automatically and needs fast responses.')),
+						style({display: 'block', marginBottom: '.5ex'}),
+						dom.span('Weight of shared junk filter', attr.title('Between 0 and 1. If larger than 0, incoming messages are also classified with the shared junk filter, and the spam probabilities are combined with this weight for the shared junk filter. If the junk filter of this account does not recognize any significant words, the probability of the shared junk filter is used as is. E.g. 0.3. SharedWeight in configuration file.')),
+						dom.br(),
+						junkSharedWeight=dom.input(attr.type('number'), attr.min('0'), attr.max('1'), attr.step('0.05'), attr.value(''+(config.JunkFilter.SharedWeight || 0))),
 					),
+					dom.submitbutton('Save'),
 				),
-				dom.submitbutton('Save'),
+				async function submit(e: SubmitEvent) {
+					e.stopPropagation()
+					e.preventDefault()
+					await check(fieldsetJunkShared, client.AccountJunkFilterSharedSave(name, junkContributeShared.checked, parseFloat(junkSharedWeight.value) || 0))
+				},
 			),
-			async function submit(e: SubmitEvent) {
-				e.stopPropagation()
-				e.preventDefault()
-				await check(fieldsetSettings, client.AccountSettingsSave(name, parseInt(maxOutgoingMessagesPerDay.value) || 0, parseInt(maxFirstTimeRecipientsPerDay.value) || 0, xparseSize(quotaMessageSize.value), firstTimeSenderDelay.checked))
-			},
-		),
-		dom.br(),
+			dom.br(),
+		],
 		dom.h2('Set new password'),
 		formPassword=dom.form(
 			fieldsetPassword=dom.fieldset(
@@ -990,12 +2202,174 @@ const account = async (name: string) => {
 				formPassword.reset()
 			},
 		),
+		dom.p(
+			passwordChangeRequired ? box(yellow, 'The user must change the password. Until then, the password is only accepted for logging in to the account web interface.') : [],
+			dom.clickbutton(passwordChangeRequired ? 'No longer require password change' : 'Require password change', attr.title('Require the user to change the password, e.g. after it may have been compromised. Until changed, the password is only accepted for logging in to the account web interface, not for webmail, IMAP and SMTP submission. Web sessions are ended. App passwords keep working.'), async function click(e: MouseEvent) {
+				await check(e.target! as HTMLButtonElement, client.AccountPasswordChangeRequire(name, !passwordChangeRequired))
+				window.location.reload() // todo: only update the button
+			}),
+		),
+		dom.br(),
+		adminScope.LoginAddress ? [] : [
+			RoutesEditor('account-specific', transports, config.Routes || [], async (routes: api.Route[]) => await client.AccountRoutesSave(name, routes)),
+			dom.br(),
+		],
+		FooterEditor('this account', config.Footer || {Text: [], HTML: [], SkipReplies: false}, async (f: api.Footer | null) => await client.AccountFooterSave(name, f)),
+		dom.br(),
+
+		dom.h2('Sessions'),
+		dom.p('Active and recent IMAP and SMTP submission sessions. Closed sessions can log in again, unless the password is changed.'),
+		dom.table(dom._class('hover'),
+			dom.thead(
+				dom.tr(
+					dom.th('Protocol'),
+					dom.th('Login address'),
+					dom.th('Remote IP'),
+					dom.th('Client'),
+					dom.th('Started'),
+					dom.th('Last activity'),
+					dom.th('Status'),
+					dom.th('Action'),
+				),
+			),
+			dom.tbody(
+				(sessions || []).length === 0 ? dom.tr(dom.td(attr.colspan('8'), '(None)')) : [],
+				(sessions || []).map(s =>
+					dom.tr(
+						dom.td(s.Protocol),
+						dom.td(s.LoginAddress),
+						dom.td(s.RemoteIP),
+						dom.td(s.ClientID),
+						dom.td(age(s.Started, false, nowSecs)),
+						dom.td(age(s.LastActivity, false, nowSecs)),
+						dom.td(s.Active ? 'Active' : (s.Closed ? 'Closed' : 'Ended')),
+						dom.td(
+							!s.Active ? [] : dom.clickbutton('Close', async function click(e: MouseEvent) {
+								await check(e.target! as HTMLButtonElement, client.AccountProtocolSessionClose(name, s.ID))
+								window.location.reload() // todo: reload less
+							}),
+						),
+					),
+				),
+			),
+		),
+		dom.div(
+			style({marginTop: '1ex'}),
+			dom.clickbutton('Close all active sessions', async function click(e: MouseEvent) {
+				if (!window.confirm('Are you sure you want to close all active sessions of this account?')) {
+					return
+				}
+				await check(e.target! as HTMLButtonElement, client.AccountProtocolSessionClose(name, 0))
+				window.location.reload() // todo: reload less
+			}),
+		),
 		dom.br(),
-		RoutesEditor('account-specific', transports, config.Routes || [], async (routes: api.Route[]) => await client.AccountRoutesSave(name, routes)),
+
+		dom.h2('Passkeys'),
+		dom.p('Passkeys registered by the user for logging in to the web interfaces without password.'),
+		dom.table(dom._class('hover'),
+			dom.thead(
+				dom.tr(
+					dom.th('Label'),
+					dom.th('Login address'),
+					dom.th('Created'),
+					dom.th('Last used'),
+				),
+			),
+			dom.tbody(
+				(passkeys || []).length === 0 ? dom.tr(dom.td(attr.colspan('4'), '(None)')) : [],
+				(passkeys || []).map(pk =>
+					dom.tr(
+						dom.td(pk.Label),
+						dom.td(pk.LoginAddress),
+						dom.td(age(pk.Created, false, nowSecs)),
+						dom.td(pk.LastUsed.getTime() > 0 ? age(pk.LastUsed, false, nowSecs) : 'Never'),
+					),
+				),
+			),
+		),
+		(passkeys || []).length === 0 ? [] : dom.div(
+			style({marginTop: '1ex'}),
+			dom.clickbutton('Remove all passkeys', attr.title('E.g. when the user lost a device with a passkey.'), async function click(e: MouseEvent) {
+				if (!window.confirm('Are you sure you want to remove all passkeys of this account?')) {
+					return
+				}
+				await check(e.target! as HTMLButtonElement, client.AccountPasskeysReset(name))
+				window.location.reload() // todo: reload less
+			}),
+		),
 		dom.br(),
 
+		(incidents || []).length === 0 ? [] : [
+			dom.h2('Submission incidents'),
+			dom.p('Anomalies detected in messages submitted by the account, e.g. a spike in recipients, a high bounce rate or a submission from a new network, possibly indicating a compromised account.'),
+			throttled ? dom.p(box(yellow, 'Submissions by this account are currently throttled.')) : [],
+			dom.table(dom._class('hover'),
+				dom.thead(
+					dom.tr(
+						dom.th('Time'),
+						dom.th('Source'),
+						dom.th('Remote IP'),
+						dom.th('Anomalies'),
+						dom.th('Action'),
+						dom.th('Until'),
+					),
+				),
+				dom.tbody(
+					(incidents || []).map(si =>
+						dom.tr(
+							dom.td(age(si.Time, false, nowSecs)),
+							dom.td(si.Source),
+							dom.td(si.RemoteIP),
+							dom.td((si.Anomalies || []).map(s => dom.div(s))),
+							dom.td(si.Action),
+							dom.td(si.Action !== 'throttle' ? '' : (si.Cleared ? 'Cleared' : si.Until.toLocaleString())),
+						),
+					),
+				),
+			),
+			throttled ? dom.div(
+				style({marginTop: '1ex'}),
+				dom.clickbutton('Clear throttle', attr.title('Accept submissions by this account again, e.g. after the account password has been changed. Frozen accounts must be enabled again by allowing logins and removing the queue hold rule for the account.'), async function click(e: MouseEvent) {
+					await check(e.target! as HTMLButtonElement, client.SubmissionThrottleClear(name))
+					window.location.reload() // todo: reload less
+				}),
+			) : [],
+			dom.br(),
+		],
+
 		dom.h2('Danger'),
-		dom.clickbutton('Remove account', async function click(e: MouseEvent) {
+		deletion ? dom.p(box(yellow, 'Deletion requested at ' + deletion.Requested.toLocaleString() + ' by ' + deletion.RequestedBy + ', logins are disabled. The account is purged after ' + deletion.PurgeAfter.toLocaleString() + '.')) : [],
+		deletion ? [
+			dom.clickbutton('Cancel deletion', async function click(e: MouseEvent) {
+				await check(e.target! as HTMLButtonElement, client.AccountDeletionCancel(name))
+				window.location.reload() // todo: reload less
+			}),
+			' ',
+			dom.clickbutton('Purge now', attr.title('Remove the account from the configuration, remove its data directory, remove its messages and webhooks from the queue and redact references to the account from the audit log.'), async function click(e: MouseEvent) {
+				if (!window.confirm('Are you sure you want to purge this account and all its data now? This cannot be undone.')) {
+					return
+				}
+				await check(e.target! as HTMLButtonElement, client.AccountDeletionPurge(name))
+				window.location.hash = '#accounts'
+			}),
+		] : dom.clickbutton('Request deletion', attr.title('Disable logins to the account and close its IMAP/SMTP connections, and purge the account and all its data after a grace period. During the grace period, incoming messages are still accepted, the deletion can be canceled and the data can still be downloaded.'), async function click(e: MouseEvent) {
+			const s = window.prompt('Grace period before the account is purged, e.g. 30d or 1w. If 0, the account is purged the next time pending deletions are processed.', '30d')
+			if (s === null) {
+				return
+			}
+			let grace: number
+			try {
+				grace = parseDuration(s)
+			} catch (err) {
+				window.alert('Error: ' + errmsg(err))
+				return
+			}
+			await check(e.target! as HTMLButtonElement, client.AccountDeletionRequest(name, grace))
+			window.location.reload() // todo: reload less
+		}),
+		' ',
+		dom.clickbutton('Remove account', attr.title('Remove the account from the configuration immediately. The data directory of the account is kept.'), async function click(e: MouseEvent) {
 			e.preventDefault()
 			if (!window.confirm('Are you sure you want to remove this account?')) {
 				return
@@ -1003,6 +2377,24 @@ const account = async (name: string) => {
 			await check(e.target! as HTMLButtonElement, client.AccountRemove(name))
 			window.location.hash = '#accounts'
 		}),
+		' ',
+		dom.clickbutton('Reset two-factor authentication', attr.title('Disable two-factor authentication for web logins, e.g. when the user lost access to the authenticator app and recovery codes. The user can log in with just the password and set up two-factor authentication again.'), async function click(e: MouseEvent) {
+			if (!window.confirm('Are you sure you want to reset two-factor authentication for this account?')) {
+				return
+			}
+			await check(e.target! as HTMLButtonElement, client.AccountTOTPReset(name))
+			window.alert('Two-factor authentication reset.')
+		}),
+		dom.br(),
+		dom.br(),
+		dom.form(
+			attr.target('_blank'),
+			attr.method('POST'),
+			attr.action('takeout'),
+			dom.input(attr.type('hidden'), attr.name('csrf'), attr.value(localStorageGet('webadmincsrftoken') || '')),
+			dom.input(attr.type('hidden'), attr.name('account'), attr.value(name)),
+			dom.submitbutton('Download takeout', attr.title('Download all data of the account in a single archive: account configuration, settings, filter rules, contacts, calendar events and all mailboxes with messages in maildir format.')),
+		),
 	)
 }
 
@@ -1043,7 +2435,7 @@ const formatDuration = (v: number, goDuration?: boolean) => {
 const domain = async (d: string) => {
 	const end = new Date()
 	const start = new Date(new Date().getTime() - 30*24*3600*1000)
-	const [dmarcSummaries, tlsrptSummaries, [localpartAccounts, localpartAliases], dnsdomain, clientConfigs, accounts, domainConfig, transports] = await Promise.all([
+	const [dmarcSummaries, tlsrptSummaries, [localpartAccounts, localpartAliases], dnsdomain, clientConfigs, accounts, domainConfig, transports, mtastsPromotion, setups] = await Promise.all([
 		client.DMARCSummaries(start, end, d),
 		client.TLSRPTSummaries(start, end, d),
 		client.DomainLocalparts(d),
@@ -1052,6 +2444,8 @@ const domain = async (d: string) => {
 		client.Accounts(),
 		client.DomainConfig(d),
 		client.Transports(),
+		client.DomainMTASTSPromotion(d),
+		client.DomainSetups(),
 	])
 
 	let addrForm: HTMLFormElement
@@ -1066,6 +2460,9 @@ const domain = async (d: string) => {
 	let descrFieldset: HTMLFieldSetElement
 	let descrText: HTMLInputElement
 
+	let domainAdminsFieldset: HTMLFieldSetElement
+	let domainAdmins: HTMLTextAreaElement
+
 	let clientSettingsDomainFieldset: HTMLFieldSetElement
 	let clientSettingsDomain: HTMLInputElement
 
@@ -1073,6 +2470,14 @@ const domain = async (d: string) => {
 	let localpartCatchallSeparator: HTMLInputElement
 	let localpartCaseSensitive: HTMLInputElement
 
+	let senderListsFieldset: HTMLFieldSetElement
+	let senderAllow: HTMLTextAreaElement
+	let senderReject: HTMLTextAreaElement
+	let senderJunk: HTMLTextAreaElement
+
+	let spamtrapsFieldset: HTMLFieldSetElement
+	let spamtrapLocalparts: HTMLTextAreaElement
+
 	let dmarcFieldset: HTMLFieldSetElement
 	let dmarcLocalpart: HTMLInputElement
 	let dmarcDomain: HTMLInputElement
@@ -1090,6 +2495,7 @@ const domain = async (d: string) => {
 	let mtastsMode: HTMLSelectElement
 	let mtastsMaxAge: HTMLInputElement
 	let mtastsMX: HTMLTextAreaElement
+	let mtastsEnforceAfter: HTMLInputElement
 
 	const popupDKIMHeaders = (sel: api.Selector, span: HTMLSpanElement) => {
 		const l = sel.HeadersEffective || []
@@ -1222,6 +2628,7 @@ const domain = async (d: string) => {
 			crumblink('Mox Admin', '#'),
 			'Domain ' + domainString(dnsdomain),
 		),
+		(setups || []).some(s => s.Domain === domainName(dnsdomain)) ? box(yellow, 'Setup of this domain has not been completed, outgoing messages are not yet DKIM-signed. ', dom.a('Continue setup', attr.href('#domains/' + d + '/setup'))) : [],
 		dom.ul(
 			dom.li(dom.a('Required DNS records', attr.href('#domains/' + d + '/dnsrecords'))),
 			dom.li(dom.a('Check current actual DNS records and domain configuration', attr.href('#domains/' + d + '/dnscheck'))),
@@ -1229,7 +2636,7 @@ const domain = async (d: string) => {
 		dom.br(),
 
 		dom.h2('Client configuration'),
-		dom.p('If autoconfig/autodiscover does not work with an email client, use the settings below for this domain. Authenticate with email address and password. ', dom.span('Explicitly configure', attr.title('To prevent authentication mechanism downgrade attempts that may result in clients sending plain text passwords to a MitM.')), ' the first supported authentication mechanism: SCRAM-SHA-256-PLUS, SCRAM-SHA-1-PLUS, SCRAM-SHA-256, SCRAM-SHA-1, CRAM-MD5.'),
+		dom.p('If autoconfig/autodiscover does not work with an email client, use the settings below for this domain. Authenticate with email address and password. ', dom.span('Explicitly configure', attr.title('To prevent authentication mechanism downgrade attempts that may result in clients sending plain text passwords to a MitM.')), ' the first supported authentication mechanism: SCRAM-SHA-512-PLUS, SCRAM-SHA-256-PLUS, SCRAM-SHA-1-PLUS, SCRAM-SHA-512, SCRAM-SHA-256, SCRAM-SHA-1, CRAM-MD5.'),
 		dom.table(
 			dom.thead(
 				dom.tr(
@@ -1323,15 +2730,17 @@ const domain = async (d: string) => {
 					dom.th('Allowed senders', attr.title('Whether only members can send through the alias/list, or anyone.')),
 					dom.th('Send as alias address', attr.title('If enabled, messages can be sent with the alias address in the message "From" header.')),
 					dom.th('Members visible', attr.title('If enabled, members can see the addresses of other members.')),
+					dom.th('Owner', attr.title('Account that created the alias through the account web interface, if any.')),
 				),
 			),
-			Object.values(localpartAliases).length === 0 ? dom.tr(dom.td(attr.colspan('4'), 'None')) : [],
+			Object.values(localpartAliases).length === 0 ? dom.tr(dom.td(attr.colspan('5'), 'None')) : [],
 			Object.values(localpartAliases).sort((a, b) => a.LocalpartStr < b.LocalpartStr ? -1 : 1).map(a => {
 				return dom.tr(
 					dom.td(dom.a(prewrap(a.LocalpartStr), attr.href('#domains/'+d+'/alias/'+encodeURIComponent(a.LocalpartStr)))),
 					dom.td(a.PostPublic ? 'Anyone' : 'Members only'),
 					dom.td(a.AllowMsgFrom ? 'Yes' : 'No'),
 					dom.td(a.ListMembers ? 'Yes' : 'No'),
+					dom.td(a.Owner ? dom.a(a.Owner, attr.href('#accounts/'+a.Owner)) : ''),
 				)
 			}),
 		),
@@ -1346,6 +2755,7 @@ const domain = async (d: string) => {
 					PostPublic: false,
 					ListMembers: false,
 					AllowMsgFrom: false,
+					Owner: '',
 					// Ignored:
 					LocalpartStr: '',
 					Domain: dnsdomain,
@@ -1373,8 +2783,12 @@ const domain = async (d: string) => {
 		),
 		dom.br(),
 
-		RoutesEditor('domain-specific', transports, domainConfig.Routes || [], async (routes: api.Route[]) => await client.DomainRoutesSave(d, routes)),
-		dom.br(),
+		adminScope.LoginAddress ? [] : [
+			RoutesEditor('domain-specific', transports, domainConfig.Routes || [], async (routes: api.Route[]) => await client.DomainRoutesSave(d, routes)),
+			dom.br(),
+			AddressRewritesEditor('domain-specific', domainConfig.AddressRewrites || [], async (rules: api.AddressRewrite[]) => await client.DomainAddressRewritesSave(d, rules)),
+			dom.br(),
+		],
 
 		dom.h2('Settings'),
 		dom.form(
@@ -1434,6 +2848,56 @@ const domain = async (d: string) => {
 		),
 		dom.br(),
 
+		dom.h2('Sender lists', attr.title('Senders of incoming messages to addresses in this domain that are allowed, rejected or delivered to the Junk mailbox. Senders are email addresses, or domains as "@domain", also matching subdomains. Reject and junk entries are matched against the message From address and the SMTP MAIL FROM address. Allow entries skip junk filtering and DNS block list checks, but only for messages with a DMARC pass for the message From address, or an SPF pass for the MAIL FROM address. Entries in the sender lists of accounts take precedence.')),
+		dom.form(
+			async function submit(e: SubmitEvent) {
+				e.preventDefault()
+				e.stopPropagation()
+				const lines = (s: string) => s.split('\n').map(s => s.trim()).filter(s => s)
+				await check(senderListsFieldset, client.DomainSenderListsSave(d, lines(senderAllow.value), lines(senderReject.value), lines(senderJunk.value)))
+			},
+			senderListsFieldset=dom.fieldset(
+				style({display: 'flex', gap: '1em'}),
+				dom.label(
+					dom.div('Allow, one per line'),
+					senderAllow=dom.textarea((domainConfig.SenderAllow || []).join('\n'), attr.rows('3'), style({width: '20em'})),
+				),
+				dom.label(
+					dom.div('Reject, one per line'),
+					senderReject=dom.textarea((domainConfig.SenderReject || []).join('\n'), attr.rows('3'), style({width: '20em'})),
+				),
+				dom.label(
+					dom.div('Junk, one per line'),
+					senderJunk=dom.textarea((domainConfig.SenderJunk || []).join('\n'), attr.rows('3'), style({width: '20em'})),
+				),
+				dom.div(dom.span('\u00a0'), dom.div(dom.submitbutton('Save'))),
+			),
+		),
+		dom.br(),
+
+		dom.h2('Spamtraps', attr.title('Addresses in this domain that are not used for real mail, e.g. only published where address harvesters find them. Messages to spamtraps are accepted but never delivered. They train the shared junk filter as junk, and cause messages from the same remote network or validated sender domain to be rejected for 30 days. Spamtraps cannot be existing addresses or aliases.')),
+		dom.form(
+			async function submit(e: SubmitEvent) {
+				e.preventDefault()
+				e.stopPropagation()
+				const lines = (s: string) => s.split('\n').map(s => s.trim()).filter(s => s)
+				await check(spamtrapsFieldset, client.DomainSpamtrapsSave(d, lines(spamtrapLocalparts.value)))
+			},
+			spamtrapsFieldset=dom.fieldset(
+				style({display: 'flex', gap: '1em'}),
+				dom.label(
+					dom.div('Localparts, one per line'),
+					spamtrapLocalparts=dom.textarea((domainConfig.Spamtraps || []).join('\n'), attr.rows('3'), style({width: '20em'})),
+				),
+				dom.div(dom.span('\u00a0'), dom.div(dom.submitbutton('Save'))),
+			),
+		),
+		dom.p(dom.a('Spamtrap hits', attr.href('#spamtraps'))),
+		dom.br(),
+
+		FooterEditor('accounts with a message From address in this domain', domainConfig.Footer || {Text: [], HTML: [], SkipReplies: false}, async (f: api.Footer | null) => await client.DomainFooterSave(d, f)),
+		dom.br(),
+
 		dom.h2('DMARC reporting address'),
 		dom.form(
 			style({marginTop: '1ex'}),
@@ -1547,10 +3011,12 @@ const domain = async (d: string) => {
 				let mx: string[] = []
 				let mode = api.Mode.ModeNone
 				let maxAge = 0
+				let enforceAfter = 0
 				if (!mtastsPolicyID.value) {
 					mtastsMode.value = ''
 					mtastsMaxAge.value = ''
 					mtastsMX.value = ''
+					mtastsEnforceAfter.value = ''
 					if (domainConfig.MTASTS?.PolicyID && !window.confirm('Are you sure you want to remove the MTA-STS policy? Only remove policies after having served a policy with mode "none" for a long enough period, so all previously served and remotely cached policies have expired past the then-configured DNS TTL plus policy max-age period, and seen the policy with mode "none".')) {
 						return
 					}
@@ -1561,26 +3027,23 @@ const domain = async (d: string) => {
 					mode = mtastsMode.value as api.Mode
 					maxAge = parseDuration(mtastsMaxAge.value)
 					mx = mtastsMX.value ? mtastsMX.value.split('\n') : []
-					if (domainConfig.MTASTS?.PolicyID === mtastsPolicyID.value && !window.confirm('Are you sure you want to save the policy without updating the policy ID? Remote servers may hold on to the old cached policies. Policy IDs should be changed when the policy is changed. Remember to first update the policy here, then publish the new policy ID in DNS.')) {
-						return
-					}
-				}
-				await check(mtastsFieldset, client.DomainMTASTSSave(d, mtastsPolicyID.value, mode, maxAge, mx))
-				if (domainConfig.MTASTS?.PolicyID === mtastsPolicyID.value) {
-					return
+					enforceAfter = mtastsEnforceAfter.value ? parseDuration(mtastsEnforceAfter.value) : 0
 				}
-				if (domainConfig.MTASTS?.PolicyID && !mtastsPolicyID.value) {
+				const policyID = await check(mtastsFieldset, client.DomainMTASTSSave(d, mtastsPolicyID.value, mode, maxAge, mx, enforceAfter))
+				if (domainConfig.MTASTS?.PolicyID && !policyID) {
 					window.alert("Don't forget to remove the MTA-STS DNS record.")
 					domainConfig.MTASTS = null
-				} else if (mtastsPolicyID.value) {
-					if (mtastsPolicyID.value !== domainConfig.MTASTS?.PolicyID) {
-						window.alert("Don't forget to update the MTA-STS DNS record with the new policy ID, see suggested DNS records.")
+				} else if (policyID) {
+					if (policyID !== domainConfig.MTASTS?.PolicyID) {
+						mtastsPolicyID.value = policyID
+						window.alert("Don't forget to update the MTA-STS DNS record with the new policy ID " + policyID + ", see suggested DNS records.")
 					}
 					domainConfig.MTASTS = {
-						PolicyID: mtastsPolicyID.value,
+						PolicyID: policyID,
 						Mode: mode,
 						MaxAge: maxAge,
 						MX: mx,
+						EnforceAfter: enforceAfter,
 					}
 				}
 			},
@@ -1621,9 +3084,22 @@ const domain = async (d: string) => {
 					dom.div('MX hosts/patterns (optional)'),
 					mtastsMX=dom.textarea(new String((domainConfig.MTASTS?.MX || []).join('\n')), attr.rows(''+Math.max(2, 1+(domainConfig.MTASTS?.MX || []).length))),
 				),
+				dom.label(
+					attr.title('For a policy in mode "testing", automatically change the policy to mode "enforce" with a new policy ID after this period without TLS failures in received TLS reports. The period starts again after a TLS report with failures. Leave empty to not change the mode automatically. Units: s for seconds, m for minutes, h for hours, d for day, w for weeks.'),
+					dom.div('Enforce after (optional)'),
+					mtastsEnforceAfter=dom.input(attr.value(domainConfig.MTASTS?.EnforceAfter ? formatDuration(domainConfig.MTASTS?.EnforceAfter || 0) : '')),
+				),
 				dom.div(dom.span('\u00a0'), dom.div(dom.submitbutton('Save'))),
 			),
 		),
+		!mtastsPromotion.PolicyID ? [] : dom.p(
+			'Current mode: ', mtastsPromotion.Mode || 'none', '. ',
+			mtastsPromotion.Mode !== api.Mode.ModeTesting || !mtastsPromotion.EnforceAfter ? [] : (
+				mtastsPromotion.PromoteAt.getTime() > 0 ?
+					['Promotion to mode enforce in ', age(mtastsPromotion.PromoteAt, true, 0), ', based on ', ''+mtastsPromotion.CleanReports, ' TLS report(s) without failures', mtastsPromotion.LastFailure.getTime() > 0 ? [' since last failure ', age(mtastsPromotion.LastFailure, false, 0)] : [], '.'] :
+					['Waiting for TLS reports without failures before promotion to mode enforce', mtastsPromotion.LastFailure.getTime() > 0 ? [', last failure ', age(mtastsPromotion.LastFailure, false, 0)] : [], '.']
+			),
+		),
 		dom.br(),
 
 		dom.h2('DKIM', attr.title('With DKIM signing, a domain is taking responsibility for (content of) emails it sends, letting receiving mail servers build up a (hopefully positive) reputation of the domain, which can help with mail delivery.')),
@@ -1757,21 +3233,44 @@ const domain = async (d: string) => {
 		})(),
 		dom.br(),
 
+		adminScope.LoginAddress ? [] : [
+			dom.h2('Domain admins', attr.title('Login addresses of accounts that can manage this domain in the admin web interface, with the email address and password of their account. Domain admins can manage accounts, addresses, aliases, DKIM keys and reporting addresses of their domains, and view DMARC and TLS reports. They cannot see other domains or server-wide settings.')),
+			dom.form(
+				async function submit(e: SubmitEvent) {
+					e.preventDefault()
+					e.stopPropagation()
+					const admins = domainAdmins.value.split('\n').map(s => s.trim()).filter(s => s)
+					await check(domainAdminsFieldset, client.DomainAdminsSave(d, admins))
+				},
+				domainAdminsFieldset=dom.fieldset(
+					style({display: 'flex', gap: '1em'}),
+					dom.label(
+						dom.div('Login addresses, one per line'),
+						domainAdmins=dom.textarea((domainConfig.Admins || []).join('\n'), attr.rows('3'), style({width: '30em'})),
+					),
+					dom.div(dom.span('\u00a0'), dom.div(dom.submitbutton('Save'))),
+				),
+			),
+			dom.br(),
+		],
+
 		dom.h2('External checks'),
 		dom.ul(
 			dom.li(link('https://internet.nl/mail/'+dnsdomain.ASCII+'/', 'Check configuration at internet.nl')),
 		),
 		dom.br(),
 
-		dom.h2('Danger'),
-		dom.clickbutton('Remove domain', async function click(e: MouseEvent) {
-			e.preventDefault()
-			if (!window.confirm('Are you sure you want to remove this domain?')) {
-				return
-			}
-			await check(e.target! as HTMLButtonElement, client.DomainRemove(d))
-			window.location.hash = '#'
-		}),
+		adminScope.LoginAddress ? [] : [
+			dom.h2('Danger'),
+			dom.clickbutton('Remove domain', async function click(e: MouseEvent) {
+				e.preventDefault()
+				if (!window.confirm('Are you sure you want to remove this domain?')) {
+					return
+				}
+				await check(e.target! as HTMLButtonElement, client.DomainRemove(d))
+				window.location.hash = '#'
+			}),
+		],
 	)
 }
 
@@ -1786,6 +3285,8 @@ const domainAlias = async (d: string, aliasLocalpart: string) => {
 	let postPublic: HTMLInputElement
 	let listMembers: HTMLInputElement
 	let allowMsgFrom: HTMLInputElement
+	let moderated: HTMLInputElement
+	let ownerAddress: HTMLInputElement
 
 	let addFieldset: HTMLFieldSetElement
 	let addAddress: HTMLTextAreaElement
@@ -1804,7 +3305,7 @@ const domainAlias = async (d: string, aliasLocalpart: string) => {
 			async function submit(e: SubmitEvent) {
 				e.preventDefault()
 				e.stopPropagation()
-				check(aliasFieldset, client.AliasUpdate(aliasLocalpart, d, postPublic.checked, listMembers.checked, allowMsgFrom.checked))
+				check(aliasFieldset, client.AliasUpdate(aliasLocalpart, d, postPublic.checked, listMembers.checked, allowMsgFrom.checked, ownerAddress.value.trim(), moderated.checked))
 			},
 			aliasFieldset=dom.fieldset(
 				style({display: 'flex', flexDirection: 'column', gap: '.5ex'}),
@@ -1820,6 +3321,15 @@ const domainAlias = async (d: string, aliasLocalpart: string) => {
 					allowMsgFrom=dom.input(attr.type('checkbox'), alias.AllowMsgFrom ? attr.checked('') : []),
 					' Allow messages to use the alias address in the message From header',
 				),
+				dom.label(
+					moderated=dom.input(attr.type('checkbox'), alias.Moderated ? attr.checked('') : []),
+					' Moderated, hold messages from non-members for approval by the owner instead of rejecting them',
+					attr.title('Only applies if the alias is not public. The owner address is sent a message with a link to approve or reject the held message.'),
+				),
+				dom.label(
+					dom.div('Owner address', attr.title('Local address that receives delivery failures for messages forwarded to external members, and requests to approve held messages. If empty, the postmaster address of the domain is used.')),
+					ownerAddress=dom.input(attr.value(alias.OwnerAddress), attr.placeholder('postmaster@' + domainName(domain.Domain))),
+				),
 				dom.div(style({marginTop: '1ex'}), dom.submitbutton('Save')),
 			),
 		),
@@ -1835,11 +3345,12 @@ const domainAlias = async (d: string, aliasLocalpart: string) => {
 				),
 			),
 			dom.tbody(
-				(alias.Addresses || []).map((address, index) => {
-					const pa = (alias.ParsedAddresses || [])[index]
+				(alias.Addresses || []).map(address => {
+					// Addresses in domains that are not configured are external members, without account.
+					const pa = (alias.ParsedAddresses || []).find(pa => [pa.Address.Localpart+'@'+pa.Address.Domain.ASCII, pa.Address.Localpart+'@'+domainName(pa.Address.Domain)].some(s => s.toLowerCase() === address.toLowerCase()))
 					return dom.tr(
 						dom.td(prewrap(address)),
-						dom.td(dom.a(pa.AccountName, attr.href('#accounts/'+pa.AccountName))),
+						dom.td(pa ? dom.a(pa.AccountName, attr.href('#accounts/'+pa.AccountName)) : '(external)'),
 						dom.td(
 							dom.clickbutton('Remove', async function click(e: MouseEvent) {
 								await check(e.target! as HTMLButtonElement, client.AliasAddressesRemove(aliasLocalpart, d, [address]))
@@ -1891,6 +3402,235 @@ const domainAlias = async (d: string, aliasLocalpart: string) => {
 	)
 }
 
+const domainSetup = async (d: string) => {
+	const dnsdomain = await client.ParseDomain(d)
+	const hash = window.location.hash
+
+	const copyButton = (text: () => string) => dom.clickbutton('Copy', attr.title('Copy to clipboard.'), async function click(e: MouseEvent) {
+		const b = e.target! as HTMLButtonElement
+		try {
+			await window.navigator.clipboard.writeText(text())
+			b.innerText = 'Copied'
+			setTimeout(() => b.innerText = 'Copy', 2000)
+		} catch (err) {
+			window.alert('Error copying to clipboard: ' + errmsg(err))
+		}
+	})
+
+	// Name relative to the domain, as most DNS provider web interfaces expect.
+	const relativeName = (name: string) => {
+		const zone = dnsdomain.ASCII + '.'
+		if (name === zone) {
+			return '@'
+		} else if (name.endsWith('.' + zone)) {
+			return name.substring(0, name.length-zone.length-1)
+		}
+		return name
+	}
+
+	// TXT records as quoted strings of at most 255 characters.
+	const rdata = (rr: api.DomainSetupRecord) => {
+		if (rr.Type !== 'TXT') {
+			return rr.Value
+		}
+		const l: string[] = []
+		for (let i = 0; i === 0 || i < rr.Value.length; i += 255) {
+			l.push('"' + rr.Value.substring(i, i+255) + '"')
+		}
+		return l.join(' ')
+	}
+
+	const providers: {[k: string]: {name: string, about: string, snippet?: (l: api.DomainSetupRecord[]) => string}} = {
+		webui: {
+			name: 'Web interface',
+			about: 'For DNS providers with a web interface for adding records one by one. Most ask for a name relative to the domain, with "@" for the domain itself. For TXT records, paste the value without quotes.',
+		},
+		zone: {
+			name: 'Zone file',
+			about: 'For DNS servers like BIND, NSD and Knot, and for DNS providers that can import records in zone file syntax, such as Cloudflare.',
+			snippet: (l) => ['$TTL 300', ...l.map(rr => rr.Zone)].join('\n') + '\n',
+		},
+		nsupdate: {
+			name: 'nsupdate',
+			about: 'For DNS servers that accept dynamic updates (RFC 2136), e.g. with "nsupdate -k keyfile". Existing records are not removed.',
+			snippet: (l) => [...l.map(rr => 'update add ' + rr.Name + ' 300 ' + rr.Type + ' ' + rdata(rr)), 'send'].join('\n') + '\n',
+		},
+		route53: {
+			name: 'Route 53',
+			about: 'For Amazon Route 53, as change batch for "aws route53 change-resource-record-sets --hosted-zone-id ... --change-batch file://records.json". An UPSERT replaces all existing records of the same name and type, add existing TXT records for the domain, e.g. for other verifications, before applying.',
+			snippet: (l) => {
+				const sets: {[k: string]: {Name: string, Type: string, TTL: number, ResourceRecords: {Value: string}[]}} = {}
+				for (const rr of l) {
+					const k = rr.Name + ' ' + rr.Type
+					sets[k] = sets[k] || {Name: rr.Name, Type: rr.Type, TTL: 300, ResourceRecords: []}
+					sets[k].ResourceRecords.push({Value: rdata(rr)})
+				}
+				const changes = Object.values(sets).map(rrset => ({Action: 'UPSERT', ResourceRecordSet: rrset}))
+				return JSON.stringify({Comment: 'Mail for ' + dnsdomain.ASCII, Changes: changes}, undefined, '\t') + '\n'
+			},
+		},
+	}
+	let provider = 'webui'
+
+	let status: api.DomainSetupStatus | null = null
+	let stopped = false
+
+	let statusBox: HTMLElement
+	let recordsBox: HTMLElement
+	let checkButton: HTMLButtonElement
+	let activateButton: HTMLButtonElement
+
+	const checkStatus = (name: string) => {
+		const c = (status?.Checks || []).find(c => c.Name === name)
+		if (!c) {
+			return dom.span('Optional', attr.title('Recommended, but not required for activating the setup.'))
+		} else if ((c.Errors || []).length > 0) {
+			return dom.span(style({backgroundColor: red, padding: '0 .25em', borderRadius: '3px'}), 'Not valid', attr.title((c.Errors || []).join('\n')))
+		}
+		return dom.span(style({backgroundColor: green, padding: '0 .25em', borderRadius: '3px'}), 'OK')
+	}
+
+	const renderRecords = () => {
+		if (!status) {
+			return
+		}
+		const records = status.Records || []
+		const required = records.filter(rr => rr.Check)
+		const optional = records.filter(rr => !rr.Check)
+		const p = providers[provider]
+		const table = (l: api.DomainSetupRecord[]) => dom.table(dom._class('hover'),
+			dom.thead(
+				dom.tr(
+					dom.th('Status'),
+					dom.th('Name'),
+					dom.th('Type'),
+					dom.th('Value'),
+				),
+			),
+			dom.tbody(
+				l.length === 0 ? dom.tr(dom.td(attr.colspan('4'), '(None)')) : [],
+				l.map(rr =>
+					dom.tr(
+						dom.td(checkStatus(rr.Check)),
+						dom.td(relativeName(rr.Name), ' ', copyButton(() => relativeName(rr.Name))),
+						dom.td(rr.Type),
+						dom.td(dom.div(style({maxWidth: '50em', wordBreak: 'break-all'}), rr.Value), copyButton(() => rr.Value)),
+					),
+				),
+			),
+		)
+		const snippet = (l: api.DomainSetupRecord[]) => [
+			dom.pre(dom._class('literal'), style({maxWidth: '70em', whiteSpace: 'pre-wrap', wordBreak: 'break-all'}), p.snippet!(l)),
+			copyButton(() => p.snippet!(l)),
+		]
+		dom._kids(recordsBox,
+			dom.div(
+				Object.entries(providers).map(([k, v]) =>
+					[
+						dom.clickbutton(v.name, k === provider ? style({fontWeight: 'bold'}) : [], function click() {
+							provider = k
+							renderRecords()
+						}),
+						' ',
+					]
+				),
+			),
+			dom.p(p.about),
+			dom.h2('Required records'),
+			p.snippet ? snippet(required) : table(required),
+			dom.br(),
+			dom.h2('Recommended records'),
+			dom.p('These records are not required for activating the setup. Some are for the machine and only have to be created once, for the first domain.'),
+			p.snippet ? snippet(optional) : table(optional),
+		)
+	}
+
+	const renderStatus = () => {
+		if (!status) {
+			return
+		}
+		activateButton.disabled = !status.Ready
+		dom._kids(statusBox,
+			status.Ready ? box(green, 'All required DNS records are valid, the setup can be activated.') : box(yellow, 'Waiting for valid DNS records. Changes can take a while to be visible, depending on TTLs. Checking again every 30 seconds.'),
+			dom.div('Last checked: ', status.Setup.LastCheck.toLocaleString()),
+			dom.ul(
+				(status.Checks || []).map(c =>
+					dom.li(
+						c.Name, ': ', checkStatus(c.Name),
+						(c.Errors || []).length === 0 ? [] : dom.ul((c.Errors || []).map(s => dom.li(s))),
+					),
+				),
+			),
+		)
+	}
+
+	const refresh = async () => {
+		try {
+			checkButton.disabled = true
+			status = await client.DomainSetupCheck(d)
+		} catch (err) {
+			stopped = true
+			dom._kids(statusBox, box(red, 'Error checking DNS records: ' + errmsg(err)))
+			return
+		} finally {
+			checkButton.disabled = false
+		}
+		renderStatus()
+		renderRecords()
+	}
+
+	// Keep checking while the page is shown, until the records are valid.
+	const poll = async () => {
+		if (stopped || window.location.hash !== hash) {
+			return
+		}
+		await refresh()
+		if (!stopped && !status?.Ready) {
+			setTimeout(poll, 30*1000)
+		}
+	}
+
+	dom._kids(page,
+		crumbs(
+			crumblink('Mox Admin', '#'),
+			crumblink('Domain ' + domainString(dnsdomain), '#domains/'+d),
+			'Setup',
+		),
+		dom.p('The domain has been added, with new DKIM keys. Create the DNS records below. Once the required records are valid, activate the setup to start signing outgoing messages with DKIM, and to change the MTA-STS policy to mode enforce. Until then, outgoing messages are not DKIM-signed. You can leave this page and continue later from the domain page.'),
+		dom.h2('Verification'),
+		statusBox=dom.div('Checking DNS records...'),
+		dom.div(
+			checkButton=dom.clickbutton('Check now', attr.title('Check the DNS records again.'), async function click() {
+				await refresh()
+			}),
+			' ',
+			activateButton=dom.clickbutton('Activate', attr.disabled(''), attr.title('Enable DKIM signing and change the MTA-STS policy to mode enforce. Only possible when all required DNS records are valid.'), async function click(e: MouseEvent) {
+				const policyID = await check(e.target! as HTMLButtonElement, client.DomainSetupActivate(d))
+				stopped = true
+				const txt = '_mta-sts.' + dnsdomain.ASCII + '. TXT "v=STSv1; id=' + policyID + '"'
+				dom._kids(page,
+					crumbs(
+						crumblink('Mox Admin', '#'),
+						crumblink('Domain ' + domainString(dnsdomain), '#domains/'+d),
+						'Setup',
+					),
+					box(green, 'Setup activated, outgoing messages are now signed with DKIM.'),
+					policyID ? [
+						dom.p('The MTA-STS policy is now in mode enforce, with a new policy ID. Update the DNS record so remote mail servers fetch the new policy:'),
+						dom.pre(dom._class('literal'), txt),
+						copyButton(() => txt),
+					] : [],
+					dom.p(dom.a('Back to domain', attr.href('#domains/'+d))),
+				)
+			}),
+		),
+		dom.br(),
+		dom.h2('DNS records'),
+		recordsBox=dom.div(),
+	)
+	await poll()
+}
+
 const domainDNSRecords = async (d: string) => {
 	const [records, dnsdomain] = await Promise.all([
 		client.DomainRecords(d),
@@ -2991,6 +4731,50 @@ const mtasts = async () => {
 	)
 }
 
+const acmeCertificates = async () => {
+	const certs = await client.ACMECertificates()
+	const nowSecs = new Date().getTime()/1000
+
+	dom._kids(page,
+		crumbs(
+			crumblink('Mox Admin', '#'),
+			'ACME certificates',
+		),
+		dom.p('TLS certificates requested through ACME, by host and ACME provider. When requesting a certificate from an ACME provider fails, certificates are requested from its fallback providers, if configured. Certificates are renewed early when the renewal information (ARI) of the ACME provider suggests so, e.g. when they will be revoked.'),
+		(certs || []).length === 0 ? dom.div('No certificates') :
+		dom.table(dom._class('hover'),
+			dom.thead(
+				dom.tr(
+					dom.th('Host'),
+					dom.th('Provider', attr.title('Name of the ACME provider in the configuration.')),
+					dom.th('Issuer', attr.title('Certificate authority that issued the certificate.')),
+					dom.th('Active', attr.title('Whether the certificate is used for new connections. A certificate from a fallback provider is only used if the primary provider has no certificate.')),
+					dom.th('DNS-01', attr.title('Whether the certificate was requested with a DNS-01 challenge.')),
+					dom.th('Not before'),
+					dom.th('Expires'),
+					dom.th('Renewal window', attr.title('Renewal window suggested by the ACME provider through renewal information (ARI), if supported.')),
+					dom.th('Serial number'),
+				),
+			),
+			dom.tbody(
+				(certs || []).map(c =>
+					dom.tr(
+						dom.td(c.Host),
+						dom.td(c.Provider),
+						dom.td(c.IssuerOrganization + (c.IssuerCommonName ? ' ('+c.IssuerCommonName+')' : '')),
+						dom.td(c.Active ? 'yes' : 'no'),
+						dom.td(c.DNS01 ? 'yes' : ''),
+						dom.td(age(c.NotBefore, false, nowSecs)),
+						dom.td(age(c.NotAfter, true, nowSecs)),
+						dom.td(c.RenewalStart.getTime() < 0 ? '' : [age(c.RenewalStart, true, nowSecs), ' - ', age(c.RenewalEnd, true, nowSecs)]),
+						dom.td(c.SerialNumber),
+					)
+				),
+			),
+		),
+	)
+}
+
 const formatMTASTSMX = (mx: api.STSMX[]) => {
 	return mx.map(e => {
 		return (e.Wildcard ? '*.' : '') + e.Domain.ASCII
@@ -3214,7 +4998,7 @@ const queueList = async () => {
 			msgs.map(m => {
 				return dom.tr(
 					dom.td(toggles.get(m.ID)!),
-					dom.td(''+m.ID + (m.BaseID > 0 ? '/'+m.BaseID : '')),
+					dom.td(dom.a(''+m.ID, attr.href('#messagetrace/'+m.ID), attr.title('Delivery history of message.')), m.BaseID > 0 ? '/'+m.BaseID : ''),
 					dom.td(age(new Date(m.Queued), false, nowSecs)),
 					dom.td(m.SenderAccount || '-'),
 					dom.td(prewrap(m.SenderLocalpart, "@", ipdomainString(m.SenderDomain))), // todo: escaping of localpart
@@ -3666,7 +5450,7 @@ const retiredList = async () => {
 			retired.length === 0 ? dom.tr(dom.td(attr.colspan('14'), 'No retired messages.')) : [],
 			retired.map(m =>
 				dom.tr(
-					dom.td(''+m.ID + (m.BaseID > 0 ? '/'+m.BaseID : '')),
+					dom.td(dom.a(''+m.ID, attr.href('#messagetrace/'+m.ID), attr.title('Delivery history of message.')), m.BaseID > 0 ? '/'+m.BaseID : ''),
 					dom.td(m.Success ? '✓' : ''),
 					dom.td(age(new Date(m.LastActivity), false, nowSecs)),
 					dom.td(age(new Date(m.Queued), false, nowSecs)),
@@ -4701,6 +6485,8 @@ const webserver = async () => {
 
 			const get = (): api.WebForward => {
 				return {
+					// Keep fields that can only be configured in the config file.
+					...wf,
 					StripPath: stripPath.checked,
 					URL: url.value,
 					ResponseHeaders: responseHeaders.get(),
@@ -4790,6 +6576,9 @@ const webserver = async () => {
 					StripPath: false,
 					URL: '',
 					ResponseHeaders: {},
+					HTTP2: false,
+					DialTimeout: 0,
+					ResponseTimeout: 0,
 				})
 				detailsRoot(forwardView.root)
 			} else {
@@ -4882,6 +6671,11 @@ const webserver = async () => {
 			),
 		)
 
+		// Access control, rate limit and rules can only be configured in the config file, keep them.
+		const access = wh.Access
+		const rateLimit = wh.RateLimit
+		const rules = wh.Rules
+
 		// Final "get" that returns a WebHandler that reflects the UI.
 		const get = (): api.WebHandler => {
 			const wh: api.WebHandler = {
@@ -4890,6 +6684,9 @@ const webserver = async () => {
 				PathRegexp: pathRegexp.value,
 				DontRedirectPlainHTTP: !toHTTPS.checked,
 				Compress: compress.checked,
+				Access: access,
+				RateLimit: rateLimit,
+				Rules: rules,
 				Name: '',
 				DNSDomain: {ASCII: '', Unicode: ''},
 			}
@@ -4946,6 +6743,9 @@ const webserver = async () => {
 					WebForward: {
 						StripPath: true,
 						URL: '',
+						HTTP2: false,
+						DialTimeout: 0,
+						ResponseTimeout: 0,
 					},
 					Name: '',
 					DNSDomain: {ASCII: '', Unicode: ''},
@@ -5032,6 +6832,10 @@ const webserver = async () => {
 }
 
 const init = async () => {
+	await i18nLoad()
+	await oidcLoginFinish()
+	adminScope = await client.AdminScope()
+
 	let curhash: string | undefined
 
 	const hashChange = async () => {
@@ -5051,6 +6855,30 @@ const init = async () => {
 				await config()
 			} else if (h === 'loglevels') {
 				await loglevels()
+			} else if (h === 'passkeys') {
+				await passkeys()
+			} else if (h === 'apitokens') {
+				await apitokens()
+			} else if (h === 'auditlog') {
+				await auditlog()
+			} else if (h === 'quarantine') {
+				await quarantine()
+			} else if (h === 'spamtraps') {
+				await spamtraps()
+			} else if (h === 'lineendings') {
+				await lineendings()
+			} else if (h === 'autobans') {
+				await autobans()
+			} else if (h === 'abusereports') {
+				await abusereports()
+			} else if (h === 'logs') {
+				await logs()
+			} else if (h === 'usage') {
+				await usage()
+			} else if (h === 'messagetrace') {
+				await messagetrace('')
+			} else if (h.startsWith('messagetrace/')) {
+				await messagetrace(h.substring('messagetrace/'.length))
 			} else if (h === 'accounts') {
 				await accounts()
 			} else if (t[0] === 'accounts' && t.length === 2) {
@@ -5067,6 +6895,8 @@ const init = async () => {
 				await domainDNSCheck(t[1])
 			} else if (t[0] === 'domains' && t.length === 3 && t[2] === 'dnsrecords') {
 				await domainDNSRecords(t[1])
+			} else if (t[0] === 'domains' && t.length === 3 && t[2] === 'setup') {
+				await domainSetup(t[1])
 			} else if (h === 'queue') {
 				await queueList()
 			} else if (h === 'queue/retired') {
@@ -5099,8 +6929,12 @@ const init = async () => {
 				await mtasts()
 			} else if (h === 'dnsbl') {
 				await dnsbl()
+			} else if (h === 'acmecertificates') {
+				await acmeCertificates()
 			} else if (h === 'routes') {
 				await globalRoutes()
+			} else if (h === 'addressrewrites') {
+				await globalAddressRewrites()
 			} else if (h === 'webserver') {
 				await webserver()
 			} else {
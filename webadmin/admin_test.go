@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/http/httptest"
@@ -22,6 +23,7 @@ import (
 
 	"github.com/mjl-/sherpa"
 
+	"github.com/mjl-/mox/admindb"
 	"github.com/mjl-/mox/config"
 	"github.com/mjl-/mox/dns"
 	"github.com/mjl-/mox/mlog"
@@ -101,18 +103,18 @@ func TestAdminAuth(t *testing.T) {
 	tcheck(t, err, "sherpa handler")
 
 	respRec := httptest.NewRecorder()
-	reqInfo := requestInfo{"", respRec, &http.Request{RemoteAddr: "127.0.0.1:1234"}}
+	reqInfo := requestInfo{"", "", respRec, &http.Request{RemoteAddr: "127.0.0.1:1234"}}
 	ctx := context.WithValue(ctxbg, requestInfoCtxKey, reqInfo)
 
 	// Missing login token.
-	tneedErrorCode(t, "user:error", func() { api.Login(ctx, "", "moxtest123") })
+	tneedErrorCode(t, "user:error", func() { api.Login(ctx, "", "", "moxtest123", "") })
 
 	// Login with loginToken.
 	loginCookie := &http.Cookie{Name: "webadminlogin"}
 	loginCookie.Value = api.LoginPrep(ctx)
 	reqInfo.Request.Header = http.Header{"Cookie": []string{loginCookie.String()}}
 
-	csrfToken := api.Login(ctx, loginCookie.Value, "moxtest123")
+	csrfToken := api.Login(ctx, loginCookie.Value, "", "moxtest123", "")
 	var sessionCookie *http.Cookie
 	for _, c := range respRec.Result().Cookies() {
 		if c.Name == "webadminsession" {
@@ -127,7 +129,7 @@ func TestAdminAuth(t *testing.T) {
 	// Valid loginToken, but bad credentials.
 	loginCookie.Value = api.LoginPrep(ctx)
 	reqInfo.Request.Header = http.Header{"Cookie": []string{loginCookie.String()}}
-	tneedErrorCode(t, "user:loginFailed", func() { api.Login(ctx, loginCookie.Value, "badauth") })
+	tneedErrorCode(t, "user:loginFailed", func() { api.Login(ctx, loginCookie.Value, "", "badauth", "") })
 
 	type httpHeaders [][2]string
 	ctJSON := [2]string{"Content-Type", "application/json; charset=utf-8"}
@@ -204,6 +206,33 @@ func TestAdminAuth(t *testing.T) {
 	testHTTPAuthAPI("GET", "/api/Transports", http.StatusMethodNotAllowed, nil, nil)
 	testHTTPAuthAPI("POST", "/api/Transports", http.StatusOK, httpHeaders{ctJSON}, nil)
 
+	// Changes are added to the audit log, with error, without passwords.
+	testAudit := func(method, body string) {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/api/"+method, strings.NewReader(body))
+		req.Header.Add("Content-Type", "application/json")
+		req.Header.Add(hdrCSRFOK[0], hdrCSRFOK[1])
+		req.Header.Add(hdrSessionOK[0], hdrSessionOK[1])
+		rr := httptest.NewRecorder()
+		handle(apiHandler, false, rr, req)
+		tcompare(t, rr.Code, http.StatusOK)
+	}
+	testAudit("LogLevelSet", `{"params":["smtpserver","debug"]}`)
+	testAudit("LogLevelRemove", `{"params":["smtpserver"]}`)
+	testAudit("SetPassword", `{"params":["bogus","test1234"]}`)
+	entries, err := admindb.AuditList(ctxbg, admindb.AuditFilter{Source: "webadmin"})
+	tcheck(t, err, "audit list")
+	tcompare(t, len(entries), 3)
+	tcompare(t, entries[2].Action, "LogLevelSet")
+	tcompare(t, entries[2].Params, `["smtpserver","debug"]`)
+	tcompare(t, entries[2].Error, "")
+	tcompare(t, entries[2].RemoteIP, "192.0.2.1")
+	tcompare(t, entries[0].Action, "SetPassword")
+	tcompare(t, entries[0].Params, `["bogus","***"]`)
+	if entries[0].Error == "" {
+		t.Fatalf("missing error in audit log for failed call")
+	}
+
 	// Logout needs session token.
 	reqInfo.SessionToken = store.SessionToken(strings.SplitN(sessionCookie.Value, " ", 2)[0])
 	ctx = context.WithValue(ctxbg, requestInfoCtxKey, reqInfo)
@@ -212,6 +241,121 @@ func TestAdminAuth(t *testing.T) {
 	tneedErrorCode(t, "server:error", func() { api.Logout(ctx) })
 }
 
+func TestDomainAdmin(t *testing.T) {
+	os.RemoveAll("../testdata/webadmin/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/webadmin/mox.conf")
+	mox.ConfigDynamicPath = filepath.Join(filepath.Dir(mox.ConfigStaticPath), "domains.conf")
+	mox.MustLoadConfig(true, false)
+	defer store.Switchboard()()
+
+	log := mlog.New("webadmin", nil)
+	acc, err := store.OpenAccount(log, "mjl")
+	tcheck(t, err, "open account")
+	err = acc.SetPassword(log, "test1234")
+	tcheck(t, err, "set password")
+	err = acc.Close()
+	tcheck(t, err, "close account")
+
+	api := Admin{cookiePath: "/admin/"}
+	apiHandler, err := makeSherpaHandler(api.cookiePath, false)
+	tcheck(t, err, "sherpa handler")
+
+	api.DomainAdminsSave(ctxbg, "mox.example", []string{"mjl@mox.example"})
+	defer api.DomainAdminsSave(ctxbg, "mox.example", nil)
+	tcompare(t, mox.Conf.DomainAdminDomains("mjl@mox.example"), []dns.Domain{{ASCII: "mox.example"}})
+	tcompare(t, len(mox.Conf.DomainAdminDomains("mjl2@mox.example")), 0)
+
+	respRec := httptest.NewRecorder()
+	reqInfo := requestInfo{"", "", respRec, &http.Request{RemoteAddr: "127.0.0.1:1234"}}
+	ctx := context.WithValue(ctxbg, requestInfoCtxKey, reqInfo)
+
+	// Address that is not a domain admin cannot login, even with valid credentials.
+	loginCookie := &http.Cookie{Name: "webadminlogin"}
+	loginCookie.Value = api.LoginPrep(ctx)
+	reqInfo.Request.Header = http.Header{"Cookie": []string{loginCookie.String()}}
+	tneedErrorCode(t, "user:loginFailed", func() { api.Login(ctx, loginCookie.Value, "mjl2@mox.example", "test1234", "") })
+
+	loginCookie.Value = api.LoginPrep(ctx)
+	reqInfo.Request.Header = http.Header{"Cookie": []string{loginCookie.String()}}
+	csrfToken := api.Login(ctx, loginCookie.Value, "mjl@mox.example", "test1234", "")
+	var sessionCookie *http.Cookie
+	for _, c := range respRec.Result().Cookies() {
+		if c.Name == "webadminsession" {
+			sessionCookie = c
+		}
+	}
+	if sessionCookie == nil {
+		t.Fatalf("missing session cookie")
+	}
+
+	call := func(method, params string, result any) *sherpa.Error {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/api/"+method, strings.NewReader(`{"params":`+params+`}`))
+		req.Header.Add("Content-Type", "application/json")
+		req.Header.Add("x-mox-csrf", string(csrfToken))
+		req.Header.Add("Cookie", (&http.Cookie{Name: "webadminsession", Value: sessionCookie.Value}).String())
+		rr := httptest.NewRecorder()
+		handle(apiHandler, false, rr, req)
+		tcompare(t, rr.Code, http.StatusOK)
+		var resp struct {
+			Result any           `json:"result"`
+			Error  *sherpa.Error `json:"error"`
+		}
+		resp.Result = result
+		err := json.NewDecoder(rr.Body).Decode(&resp)
+		tcheck(t, err, "parsing response")
+		return resp.Error
+	}
+	needError := func(method, params string) {
+		t.Helper()
+		if serr := call(method, params, nil); serr == nil || serr.Code != "user:error" {
+			t.Fatalf("%s %s: got %v, expected user error", method, params, serr)
+		}
+	}
+	needOK := func(method, params string, result any) {
+		t.Helper()
+		if serr := call(method, params, result); serr != nil {
+			t.Fatalf("%s %s: %v", method, params, serr)
+		}
+	}
+
+	var scope AdminScope
+	needOK("AdminScope", "[]", &scope)
+	tcompare(t, scope, AdminScope{"mjl@mox.example", []dns.Domain{{ASCII: "mox.example"}}})
+
+	var domains []dns.Domain
+	needOK("Domains", "[]", &domains)
+	tcompare(t, domains, []dns.Domain{{ASCII: "mox.example"}})
+	var accounts []string
+	needOK("Accounts", "[]", &accounts)
+	tcompare(t, accounts, []string{"mjl"})
+	var transports map[string]config.Transport
+	needOK("Transports", "[]", &transports)
+	tcompare(t, len(transports), 0)
+
+	needOK("DomainConfig", `["mox.example"]`, nil)
+	needOK("Account", `["mjl"]`, nil)
+	needOK("DMARCSummaries", `["2024-01-01T00:00:00Z","2024-02-01T00:00:00Z","mox.example"]`, nil)
+
+	// Other domains, accounts and server-wide settings are not accessible.
+	needError("DomainConfig", `["other.example"]`)
+	needError("DMARCSummaries", `["2024-01-01T00:00:00Z","2024-02-01T00:00:00Z",""]`)
+	needError("Account", `["bogus"]`)
+	needError("AddressAdd", `["new@other.example","mjl"]`)
+	needError("AliasAddressesAdd", `["support","mox.example",["mjl@other.example"]]`)
+	needError("AccountSettingsSave", `["mjl",0,0,0,0,false]`)
+	needError("DomainRemove", `["mox.example"]`)
+	needError("Config", `[]`)
+	needError("LogLevels", `[]`)
+	needError("QueueSize", `[]`)
+	needError("APITokens", `[]`)
+
+	// Without being domain admin anymore, nothing is allowed, except logging out.
+	api.DomainAdminsSave(ctxbg, "mox.example", nil)
+	needError("Domains", "[]")
+	needOK("Logout", "[]", nil)
+}
+
 func TestAdmin(t *testing.T) {
 	os.RemoveAll("../testdata/webadmin/data")
 	defer os.RemoveAll("../testdata/webadmin/dkim")
@@ -260,6 +404,18 @@ func TestAdmin(t *testing.T) {
 	tneedErrorCode(t, "user:error", func() { api.RoutesSave(ctxbg, []config.Route{{Transport: "bogus"}}) })
 	api.RoutesSave(ctxbg, nil)
 
+	api.AddressRewritesSave(ctxbg, []config.AddressRewrite{{AddressRegexp: `^(.+)@legacy\.example$`, Replace: "$1@mox.example", Recipient: true}})
+	tneedErrorCode(t, "user:error", func() {
+		api.AddressRewritesSave(ctxbg, []config.AddressRewrite{{AddressRegexp: `(`, Replace: "x@mox.example", Recipient: true}})
+	})
+	tneedErrorCode(t, "user:error", func() {
+		api.AddressRewritesSave(ctxbg, []config.AddressRewrite{{AddressRegexp: `^x@legacy\.example$`, Replace: "x@mox.example"}})
+	})
+	api.AddressRewritesSave(ctxbg, nil)
+
+	api.DomainAddressRewritesSave(ctxbg, "mox.example", []config.AddressRewrite{{AddressRegexp: `^(.+)@mox\.example$`, Replace: "$1@mox.example", Priority: 1, Sender: true}})
+	api.DomainAddressRewritesSave(ctxbg, "mox.example", nil)
+
 	api.DomainDescriptionSave(ctxbg, "mox.example", "description")
 	tneedErrorCode(t, "server:error", func() { api.DomainDescriptionSave(ctxbg, "mox.example", "newline not ok\n") }) // todo: user error
 	tneedErrorCode(t, "user:error", func() { api.DomainDescriptionSave(ctxbg, "bogus.example", "unknown domain") })
@@ -270,6 +426,46 @@ func TestAdmin(t *testing.T) {
 	tneedErrorCode(t, "user:error", func() { api.DomainClientSettingsDomainSave(ctxbg, "bogus.example", "unknown.example") })
 	api.DomainClientSettingsDomainSave(ctxbg, "mox.example", "") // Restore.
 
+	api.DomainSenderListsSave(ctxbg, "mox.example", []string{"news@example.org"}, []string{"@spam.example"}, nil)
+	tneedErrorCode(t, "user:error", func() { api.DomainSenderListsSave(ctxbg, "mox.example", []string{"bogus"}, nil, nil) })
+	tneedErrorCode(t, "user:error", func() {
+		api.DomainSenderListsSave(ctxbg, "mox.example", []string{"@spam.example"}, []string{"@spam.example"}, nil)
+	})
+	api.DomainSenderListsSave(ctxbg, "mox.example", nil, nil, nil) // Restore.
+
+	api.DomainSpamtrapsSave(ctxbg, "mox.example", []string{"trap"})
+	tneedErrorCode(t, "user:error", func() { api.DomainSpamtrapsSave(ctxbg, "mox.example", []string{"mjl"}) }) // Existing address.
+	api.SpamtrapHits(ctxbg, 10)
+	api.DomainSpamtrapsSave(ctxbg, "mox.example", nil) // Restore.
+
+	// Delivery history of a message, by Message-ID or queue ID.
+	tneedErrorCode(t, "user:error", func() { api.MessageTrace(ctxbg, " ") })
+	tcompare(t, len(api.MessageTrace(ctxbg, "<trace@mox.example>")), 0)
+	log := mlog.New("webadmin", nil)
+	admindb.MessageEventAdd(ctxbg, log, admindb.MessageEvent{MessageID: "<trace@mox.example>", Kind: admindb.EventReceived})
+	admindb.MessageEventAdd(ctxbg, log, admindb.MessageEvent{MessageID: "<trace@mox.example>", QueueID: 1000, Kind: admindb.EventQueued})
+	admindb.MessageEventAdd(ctxbg, log, admindb.MessageEvent{QueueID: 1000, Kind: admindb.EventDelivered})
+	tcompare(t, len(api.MessageTrace(ctxbg, "trace@mox.example")), 3)
+	tcompare(t, len(api.MessageTrace(ctxbg, " 1000 ")), 3)
+
+	api.AbuseReports(ctxbg, 10)
+	tneedErrorCode(t, "user:error", func() { api.AbuseReportSetHandled(ctxbg, 1000, true) })
+
+	api.LineEndingSources(ctxbg, 10)
+
+	tneedErrorCode(t, "user:error", func() { api.AccountPasswordChangeRequire(ctxbg, "mjl", true) }) // No password.
+	tcompare(t, api.AccountPasswordChangeRequired(ctxbg, "mjl"), false)
+	tneedErrorCode(t, "user:error", func() { api.SetPassword(ctxbg, "mjl", "short") })
+
+	api.AutoBans(ctxbg)
+	tneedErrorCode(t, "user:error", func() { api.AutoBanRemove(ctxbg, "198.51.100.1/32") })
+
+	api.DomainFooterSave(ctxbg, "mox.example", &config.Footer{Text: []string{"Confidential."}, SkipReplies: true})
+	tneedErrorCode(t, "user:error", func() { api.DomainFooterSave(ctxbg, "mox.example", &config.Footer{}) }) // Empty footer.
+	api.DomainFooterSave(ctxbg, "mox.example", nil)                                                          // Restore.
+	api.AccountFooterSave(ctxbg, "mjl", &config.Footer{HTML: []string{"<p>Confidential.</p>"}})
+	api.AccountFooterSave(ctxbg, "mjl", nil) // Restore.
+
 	api.DomainLocalpartConfigSave(ctxbg, "mox.example", "-", true)
 	tneedErrorCode(t, "user:error", func() { api.DomainLocalpartConfigSave(ctxbg, "bogus.example", "", false) })
 	api.DomainLocalpartConfigSave(ctxbg, "mox.example", "", false) // Restore.
@@ -285,20 +481,26 @@ func TestAdmin(t *testing.T) {
 	api.DomainTLSRPTAddressSave(ctxbg, "mox.example", "", "", "", "") // Restore.
 
 	// todo: cannot enable mta-sts because we have no listener, which would require a tls cert for the domain.
-	// api.DomainMTASTSSave(ctxbg, "mox.example", "id0", mtasts.ModeEnforce, time.Hour, []string{"mail.mox.example"})
+	// api.DomainMTASTSSave(ctxbg, "mox.example", "id0", mtasts.ModeEnforce, time.Hour, []string{"mail.mox.example"}, 0)
+	tneedErrorCode(t, "user:error", func() {
+		api.DomainMTASTSSave(ctxbg, "bogus.example", "id0", mtasts.ModeEnforce, time.Hour, []string{"mail.mox.example"}, 0)
+	})
 	tneedErrorCode(t, "user:error", func() {
-		api.DomainMTASTSSave(ctxbg, "bogus.example", "id0", mtasts.ModeEnforce, time.Hour, []string{"mail.mox.example"})
+		api.DomainMTASTSSave(ctxbg, "mox.example", "invalid id", mtasts.ModeEnforce, time.Hour, []string{"mail.mox.example"}, 0)
 	})
 	tneedErrorCode(t, "user:error", func() {
-		api.DomainMTASTSSave(ctxbg, "mox.example", "invalid id", mtasts.ModeEnforce, time.Hour, []string{"mail.mox.example"})
+		api.DomainMTASTSSave(ctxbg, "mox.example", "id0", mtasts.Mode("bogus"), time.Hour, []string{"mail.mox.example"}, 0)
 	})
 	tneedErrorCode(t, "user:error", func() {
-		api.DomainMTASTSSave(ctxbg, "mox.example", "id0", mtasts.Mode("bogus"), time.Hour, []string{"mail.mox.example"})
+		api.DomainMTASTSSave(ctxbg, "mox.example", "id0", mtasts.ModeEnforce, time.Hour, []string{"*.*.mail.mox.example"}, 0)
 	})
 	tneedErrorCode(t, "user:error", func() {
-		api.DomainMTASTSSave(ctxbg, "mox.example", "id0", mtasts.ModeEnforce, time.Hour, []string{"*.*.mail.mox.example"})
+		api.DomainMTASTSSave(ctxbg, "mox.example", "id0", mtasts.ModeTesting, time.Hour, []string{"mail.mox.example"}, -time.Hour)
 	})
-	api.DomainMTASTSSave(ctxbg, "mox.example", "", mtasts.ModeNone, 0, nil) // Restore.
+	api.DomainMTASTSSave(ctxbg, "mox.example", "", mtasts.ModeNone, 0, nil, 0) // Restore.
+	if st := api.DomainMTASTSPromotion(ctxbg, "mox.example"); st.PolicyID != "" || !st.PromoteAt.IsZero() {
+		t.Fatalf("mtasts promotion status for domain without policy, got %#v", st)
+	}
 
 	api.DomainDKIMAdd(ctxbg, "mox.example", "testsel", "ed25519", "sha256", true, true, true, nil, 24*time.Hour)
 	tneedErrorCode(t, "user:error", func() {
@@ -330,9 +532,9 @@ func TestAdmin(t *testing.T) {
 	tneedErrorCode(t, "user:error", func() { api.AliasAdd(ctxbg, "support", "bogus.example", alias) })         // Unknown domain.
 	tneedErrorCode(t, "user:error", func() { api.AliasAdd(ctxbg, "support2", "mox.example", config.Alias{}) }) // No addresses.
 
-	api.AliasUpdate(ctxbg, "support", "mox.example", true, true, true)
-	tneedErrorCode(t, "user:error", func() { api.AliasUpdate(ctxbg, "bogus", "mox.example", true, true, true) })     // Unknown alias localpart.
-	tneedErrorCode(t, "user:error", func() { api.AliasUpdate(ctxbg, "support", "bogus.example", true, true, true) }) // Unknown alias domain.
+	api.AliasUpdate(ctxbg, "support", "mox.example", true, true, true, "", false)
+	tneedErrorCode(t, "user:error", func() { api.AliasUpdate(ctxbg, "bogus", "mox.example", true, true, true, "", false) })     // Unknown alias localpart.
+	tneedErrorCode(t, "user:error", func() { api.AliasUpdate(ctxbg, "support", "bogus.example", true, true, true, "", false) }) // Unknown alias domain.
 
 	tneedErrorCode(t, "user:error", func() {
 		api.AliasAddressesAdd(ctxbg, "support", "mox.example", []string{"mjl2@mox.example", "mjl2@mox.example"})
@@ -340,11 +542,16 @@ func TestAdmin(t *testing.T) {
 	api.AliasAddressesAdd(ctxbg, "support", "mox.example", []string{"mjl2@mox.example"})
 	tneedErrorCode(t, "user:error", func() { api.AliasAddressesAdd(ctxbg, "support", "mox.example", []string{"mjl2@mox.example"}) })    // Already present.
 	tneedErrorCode(t, "user:error", func() { api.AliasAddressesAdd(ctxbg, "support", "mox.example", []string{"bogus@mox.example"}) })   // Unknown dest localpart.
-	tneedErrorCode(t, "user:error", func() { api.AliasAddressesAdd(ctxbg, "support", "mox.example", []string{"bogus@bogus.example"}) }) // Unknown dest domain.
 	tneedErrorCode(t, "user:error", func() { api.AliasAddressesAdd(ctxbg, "support2", "mox.example", []string{"mjl@mox.example"}) })    // Unknown alias localpart.
 	tneedErrorCode(t, "user:error", func() { api.AliasAddressesAdd(ctxbg, "support", "bogus.example", []string{"mjl@mox.example"}) })   // Unknown alias localpart.
 	tneedErrorCode(t, "user:error", func() { api.AliasAddressesAdd(ctxbg, "support", "mox.example", []string{"support@mox.example"}) }) // Alias cannot be destination.
 
+	// Addresses in other domains are external members.
+	api.AliasAddressesAdd(ctxbg, "support", "mox.example", []string{"ext@remote.example"})
+	api.AliasUpdate(ctxbg, "support", "mox.example", false, true, true, "mjl@mox.example", true)
+	tneedErrorCode(t, "user:error", func() { api.AliasUpdate(ctxbg, "support", "mox.example", false, true, true, "bogus@mox.example", true) }) // Owner must be local.
+	api.AliasAddressesRemove(ctxbg, "support", "mox.example", []string{"ext@remote.example"})
+
 	tneedErrorCode(t, "user:error", func() { api.AliasAddressesRemove(ctxbg, "support", "mox.example", []string{}) })                      // Need at least 1 address.
 	tneedErrorCode(t, "user:error", func() { api.AliasAddressesRemove(ctxbg, "support", "mox.example", []string{"bogus@mox.example"}) })   // Not a member.
 	tneedErrorCode(t, "user:error", func() { api.AliasAddressesRemove(ctxbg, "support", "mox.example", []string{"bogus@bogus.example"}) }) // Not member, unknown domain.
@@ -359,6 +566,39 @@ func TestAdmin(t *testing.T) {
 	tneedErrorCode(t, "user:error", func() { api.AliasRemove(ctxbg, "support", "mox.example") })   // No longer exists.
 	tneedErrorCode(t, "user:error", func() { api.AliasRemove(ctxbg, "support", "bogus.example") }) // Unknown alias domain.
 
+	// Domain added with the wizard only signs after activating the setup.
+	api.DomainSetupAdd(ctxbg, "setup.example", "mjl", "")
+	tneedErrorCode(t, "user:error", func() { api.DomainSetupAdd(ctxbg, "setup.example", "mjl", "") }) // Already exists.
+	setupDom := dns.Domain{ASCII: "setup.example"}
+	dc, _ := mox.Conf.Domain(setupDom)
+	tcompare(t, len(dc.DKIM.Sign), 0)
+	setups := api.DomainSetups(ctxbg)
+	tcompare(t, len(setups), 1)
+	tcompare(t, setups[0].Domain, "setup.example")
+	tcompare(t, len(setups[0].Sign), 2)
+
+	lines, err := mox.DomainRecords(dc, setupDom, false, "", "")
+	tcheck(t, err, "dns records")
+	var ndkim int
+	for _, rr := range domainSetupRecords(lines, setupDom, mox.Conf.Static.HostnameDomain, setups[0].MTASTSEnforce) {
+		if rr.Check == "DKIM" {
+			ndkim++
+			if !strings.HasPrefix(rr.Value, "v=DKIM1;") || strings.Contains(rr.Value, `"`) {
+				t.Fatalf("bad dkim record value %q", rr.Value)
+			}
+		} else if rr.Name == "setup.example." && rr.Type == "MX" {
+			tcompare(t, rr.Check, "MX")
+			tcompare(t, rr.Value, "10 mox.example.")
+		}
+	}
+	tcompare(t, ndkim, 4)
+
+	domainSetupActivate(ctxbg, setups[0])
+	dc, _ = mox.Conf.Domain(setupDom)
+	tcompare(t, dc.DKIM.Sign, setups[0].Sign)
+	tcompare(t, len(api.DomainSetups(ctxbg)), 0)
+	api.DomainRemove(ctxbg, "setup.example")
+
 }
 
 func TestCheckDomain(t *testing.T) {
@@ -432,3 +672,39 @@ func TestCheckDomain(t *testing.T) {
 	Admin{}.Domains(ctxbg)             // todo: check results
 	dnsblsStatus(ctxbg, log, resolver) // todo: check results
 }
+
+func TestLogRecent(t *testing.T) {
+	mlog.SetRecent(10)
+	defer mlog.SetRecent(0)
+
+	log := mlog.New("smtpserver", nil)
+	log.Print("incoming message delivered", slog.String("account", "mjl"), slog.String("messageid", "<test@mox.example>"), slog.Int64("cid", 0x1f))
+	log.Print("other", slog.String("account", "other"), slog.Int64("cid", 0x20))
+	mlog.New("queue", nil).Print("delivered from queue", slog.String("messageid", "test@mox.example"))
+
+	api := Admin{}
+	l := api.LogRecent(ctxbg, LogFilter{})
+	tcompare(t, len(l), 3)
+	tcompare(t, l[0].Message, "delivered from queue")
+	tcompare(t, l[0].Pkg, "queue")
+	tcompare(t, l[0].Level, "print")
+
+	l = api.LogRecent(ctxbg, LogFilter{Account: "mjl"})
+	tcompare(t, len(l), 1)
+	tcompare(t, l[0].Fields[0], LogField{"account", "mjl"})
+
+	l = api.LogRecent(ctxbg, LogFilter{MessageID: "<test@mox.example>"})
+	tcompare(t, len(l), 2)
+
+	l = api.LogRecent(ctxbg, LogFilter{Cid: "20"})
+	tcompare(t, len(l), 1)
+	tcompare(t, l[0].Message, "other")
+
+	l = api.LogRecent(ctxbg, LogFilter{Pkg: "smtpserver", Text: "DELIVERED"})
+	tcompare(t, len(l), 1)
+
+	l = api.LogRecent(ctxbg, LogFilter{Max: 1})
+	tcompare(t, len(l), 1)
+
+	tneedErrorCode(t, "user:error", func() { api.LogRecent(ctxbg, LogFilter{Level: "bogus"}) })
+}
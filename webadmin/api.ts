@@ -2,6 +2,24 @@
 
 namespace api {
 
+// PasskeyRequestOptions are the parameters for logging in with a passkey with
+// navigator.credentials.get in the browser.
+export interface PasskeyRequestOptions {
+	Challenge: string
+	RPID: string
+	Timeout: number  // In milliseconds.
+}
+
+// PasskeyAssertion is the response from navigator.credentials.get, with
+// base64url encoded values.
+export interface PasskeyAssertion {
+	CredentialID: string
+	ClientDataJSON: string
+	AuthenticatorData: string
+	Signature: string
+	UserHandle: string  // User ID from registration, identifying the account.
+}
+
 // CheckResult is the analysis of a domain, its actual configuration (DNS, TLS,
 // connectivity) and the mox configuration. It includes configuration instructions
 // (e.g. DNS records), and warnings and errors encountered.
@@ -276,6 +294,15 @@ export interface ConfigDomain {
 	TLSRPT?: TLSRPT | null
 	Routes?: Route[] | null
 	Aliases?: { [key: string]: Alias }
+	RequireTOTP: boolean
+	Admins?: string[] | null
+	SenderAllow?: string[] | null
+	SenderReject?: string[] | null
+	SenderJunk?: string[] | null
+	Spamtraps?: string[] | null
+	Auth?: DomainAuth | null
+	Footer?: Footer | null
+	AddressRewrites?: AddressRewrite[] | null
 	Domain: Domain
 }
 
@@ -315,6 +342,7 @@ export interface MTASTS {
 	Mode: Mode
 	MaxAge: number
 	MX?: string[] | null
+	EnforceAfter: number
 }
 
 export interface TLSRPT {
@@ -340,9 +368,14 @@ export interface Alias {
 	PostPublic: boolean
 	ListMembers: boolean
 	AllowMsgFrom: boolean
+	Owner: string
+	OwnerAddress: string
+	Moderated: boolean
 	LocalpartStr: string  // In encoded form.
 	Domain: Domain
-	ParsedAddresses?: AliasAddress[] | null  // Matches addresses.
+	ParsedAddresses?: AliasAddress[] | null  // Matches addresses of local members.
+	ExternalAddresses?: Address[] | null  // External members, in domains not configured.
+	ParsedOwner: AliasAddress  // Zero if OwnerAddress is empty.
 }
 
 export interface AliasAddress {
@@ -377,6 +410,41 @@ export interface Ruleset {
 	ListAllowDNSDomain: Domain
 }
 
+export interface DomainAuth {
+	LDAP?: LDAPAuth | null
+	PAM?: PAMAuth | null
+	UsernameEmail: boolean
+	AutoProvision: boolean
+}
+
+export interface LDAPAuth {
+	URL: string
+	StartTLS: boolean
+	UserDNTemplate: string
+	BindDN: string
+	BindPassword: string
+	BaseDN: string
+	Filter: string
+}
+
+export interface PAMAuth {
+	Service: string
+}
+
+export interface Footer {
+	Text?: string[] | null
+	HTML?: string[] | null
+	SkipReplies: boolean
+}
+
+export interface AddressRewrite {
+	AddressRegexp: string
+	Replace: string
+	Priority: number
+	Sender: boolean
+	Recipient: boolean
+}
+
 export interface Account {
 	OutgoingWebhook?: OutgoingWebhook | null
 	IncomingWebhook?: IncomingWebhook | null
@@ -389,14 +457,20 @@ export interface Account {
 	Destinations?: { [key: string]: Destination }
 	SubjectPass: SubjectPass
 	QuotaMessageSize: number
+	CompressMessages: boolean
 	RejectsMailbox: string
 	KeepRejects: boolean
 	AutomaticJunkFlags: AutomaticJunkFlags
 	JunkFilter?: JunkFilter | null  // todo: sane defaults for junkfilter
 	MaxOutgoingMessagesPerDay: number
 	MaxFirstTimeRecipientsPerDay: number
+	MaxAliases: number
 	NoFirstTimeSenderDelay: boolean
+	RequireTOTP: boolean
+	LoginDisabled: string
 	Routes?: Route[] | null
+	Footer?: Footer | null
+	PasswordPolicy?: PasswordPolicy | null
 	DNSDomain: Domain  // Parsed form of Domain.
 	Aliases?: AddressAlias[] | null
 }
@@ -425,6 +499,9 @@ export interface AutomaticJunkFlags {
 
 export interface JunkFilter {
 	Threshold: number
+	SharedWeight: number
+	ContributeShared: boolean
+	DelayFlagTraining: boolean
 	Onegrams: boolean
 	Twograms: boolean
 	Threegrams: boolean
@@ -434,6 +511,16 @@ export interface JunkFilter {
 	RareWords: number
 }
 
+// PasswordPolicy holds requirements for new passwords.
+export interface PasswordPolicy {
+	MinLength: number
+	MinEntropy: number
+	DenyList?: string[] | null
+	DenyListFile: string
+	BreachCheck: boolean
+	BreachCheckURL: string
+}
+
 export interface AddressAlias {
 	SubscriptionAddress: string
 	Alias: Alias  // Without members.
@@ -457,6 +544,21 @@ export interface PolicyRecord {
 	PolicyText: string  // Text that make up the policy, as retrieved. We didn't store this in the past. If empty, policy can be reconstructed from Policy field. Needed by TLSRPT.
 }
 
+// CertificateInfo describes a certificate obtained through ACME.
+export interface CertificateInfo {
+	Host: string  // Hostname, or name (possibly a wildcard) for DNS-01 certificates.
+	Provider: string  // Name of the ACME provider the certificate was requested from.
+	IssuerOrganization: string
+	IssuerCommonName: string
+	SerialNumber: string  // In hexadecimal.
+	NotBefore: Date
+	NotAfter: Date
+	DNS01: boolean  // Requested with DNS-01 challenge.
+	Active: boolean  // Whether used for new connections. A certificate from a fallback provider is only used if the primary provider has no certificate.
+	RenewalStart: Date  // Start of renewal window suggested by renewal info (ARI) of the ACME provider. Zero if unknown.
+	RenewalEnd: Date
+}
+
 // Record is a TLS report as a database record, including information
 // about the sender.
 export interface TLSReportRecord {
@@ -623,6 +725,229 @@ export interface Reverse {
 	Hostnames?: string[] | null
 }
 
+// ProtocolSession is an authenticated IMAP or SMTP submission connection for an
+// account. Sessions are recorded so users and admins can see which
+// clients/devices are using an account, and close connections of a lost device.
+export interface ProtocolSession {
+	ID: number
+	Protocol: string  // E.g. "imap", "imaps", "submission", "submissions".
+	LoginAddress: string  // As used during authentication.
+	RemoteIP: string
+	ClientID: string  // Client identification, from IMAP ID command or SMTP EHLO/HELO hostname.
+	Started: Date
+	LastActivity: Date  // Only written to database when session ends, kept in memory while active.
+	Ended: Date  // Zero while active, or if mox stopped while the session was active.
+	Active: boolean  // Set when listing, whether the connection is still active.
+	Closed: boolean  // Whether the session was closed forcibly by user or admin.
+}
+
+// Passkey is a WebAuthn public key credential for logging in to the account and
+// webmail web interfaces without password. An account can have multiple
+// passkeys, e.g. one per device.
+export interface Passkey {
+	ID: number
+	Label: string  // Description, e.g. device.
+	LoginAddress: string  // Address used for sessions after logging in with this passkey.
+	Created: Date
+	LastUsed: Date  // Zero if never used.
+}
+
+// AccountDeletion is a pending deletion of an account. Logins to the account are
+// disabled while the deletion is pending. After the grace period, the account and
+// all its data are purged.
+export interface AccountDeletion {
+	ID: number
+	Account: string
+	Requested: Date
+	PurgeAfter: Date
+	RequestedBy: string  // E.g. "account", "admin" or "ctl".
+	Addresses?: string[] | null  // Addresses of the account at request time, for redacting references when purging.
+}
+
+// SubmissionIncident records anomalies detected during submission of messages by
+// an account, and the action taken.
+export interface SubmissionIncident {
+	ID: number
+	Time: Date
+	Account: string
+	Source: string  // "smtp", "webmail" or "webapi".
+	RemoteIP: string
+	Anomalies?: string[] | null  // Descriptions of the detected anomalies.
+	Action: string  // "alert", "throttle" or "freeze".
+	Until: Date  // For action "throttle", until when submissions are refused.
+	Cleared: boolean  // Set when an admin clears a throttle before it ends.
+}
+
+// SpamtrapHit is a message delivered to a spamtrap address. Hits are used as
+// reputation for the remote network and sender domain of later messages.
+export interface SpamtrapHit {
+	ID: number
+	Time: Date
+	Trap: string  // Spamtrap address.
+	RemoteIP: string
+	RemoteNetwork: string  // Remote IP masked to IPv4 /26 or IPv6 /64.
+	EHLO: string
+	MailFrom: string  // SMTP MAIL FROM, empty for the null sender.
+	Domain: string  // Organizational domain of message From address with DMARC pass, or of SPF-validated MAIL FROM. Empty if neither was validated.
+	MsgFrom: string  // Address in message From header.
+	Subject: string
+	Size: number
+}
+
+// AbuseReport is a feedback report in the Abuse Reporting Format (ARF) received at
+// a role address like abuse@ or postmaster@, about a message sent by us. Reports
+// are correlated with the outgoing messages through the delivery history. Reports
+// are kept for 90 days.
+export interface AbuseReport {
+	ID: number
+	Time: Date
+	Rcpt: string  // Role address the report was sent to.
+	ReporterFrom: string  // Validated From address of the report message.
+	FeedbackType: string  // E.g. "abuse", "fraud", "virus", "other", "not-spam".
+	UserAgent: string
+	SourceIP: string  // IP of our server the original message was received from, according to the reporter.
+	ArrivalDate: Date
+	Incidents: number
+	MessageID: string  // About the original message.; Canonical Message-ID: lower-case, without <>. Can be empty.
+	MailFrom: string  // SMTP MAIL FROM.
+	RcptTo: string  // SMTP RCPT TO addresses, separated by ", ". Often redacted by the reporter.
+	From: string  // Message From header.
+	Subject: string
+	Account: string  // Correlation with messages sent from the queue. Empty if no matching message was found.; Sending account.
+	QueueIDs?: number[] | null  // IDs of matching messages in the queue or delivery history.
+	Recipients?: string[] | null  // Recipients the report is about, from the report or the matching messages.
+	Suppressed?: string[] | null  // Recipients added to the suppression list of the account.
+	Handled: boolean  // Set by admin, to keep track of which reports need attention.
+}
+
+// LineEndingSource counts incoming messages with line ending anomalies in the SMTP
+// message data, such as bare carriage returns or newlines, per remote IP. Used to
+// see which senders would be affected when rejecting anomalies. Sources not seen
+// for 30 days are removed.
+export interface LineEndingSource {
+	ID: number
+	RemoteIP: string
+	EHLO: string  // Of the most recent message.
+	MailFromDomain: string  // Unicode. Empty for the null reverse path.
+	Submission: boolean  // Whether submitted by an authenticated account.
+	Messages: number  // Number of messages with any anomaly.
+	BareCR: number  // Messages with bare carriage returns.
+	BareLF: number  // Messages with bare newlines.
+	BadDataEnd: number  // Messages with a dot on a line with other line endings than CRLF.
+	Rejected: number  // Messages rejected due to an anomaly.
+	First: Date
+	Last: Date
+}
+
+// AutoBanEntry is a banned network.
+export interface AutoBanEntry {
+	Network: string  // E.g. 198.51.100.1/32 or 2001:db8:1:2::/64.
+	Reason: string  // "auth" or "protocol".
+	Failures: number  // Number of failures that caused the ban.
+	Start: Date
+	End: Date
+	Refused: number  // Connections and logins refused during the ban.
+}
+
+// MessageEvent is a step in the handling of a message, e.g. its receipt over
+// SMTP, the outcome of junk analysis, or an attempt at delivering it from the
+// queue. The events for a message form its delivery history, see
+// MessageEventList. Events are removed after 30 days.
+export interface MessageEvent {
+	ID: number
+	Time: Date
+	MessageID: string  // Canonical Message-ID header: lower-case, without <>. Can be empty.
+	QueueID: number  // ID of message in queue, for outgoing messages.
+	Cid: number  // Connection ID, as in the "cid" attribute in logging, in hex.
+	Kind: EventKind
+	Account: string  // Receiving account for incoming messages, sending account for outgoing messages.
+	Recipient: string  // If event is for a single recipient.
+	Remote: string  // Remote IP for received messages, remote host for deliveries from the queue.
+	Result: string  // E.g. the reason for accepting or rejecting, or the SMTP code from the remote.
+	Detail: string  // E.g. junk probability, or error or response from remote server.
+}
+
+// Usage holds the usage of an account for a domain in a calendar month, for
+// usage reporting and billing. Usage records are not removed automatically.
+export interface Usage {
+	ID: number
+	Month: string  // In UTC, e.g. "2026-10".
+	Account: string
+	Domain: string  // Domain of the recipient address for received messages, of the sender address for sent messages, and the default domain of the account for stored bytes. Unicode.
+	MessagesReceived: number  // Incoming messages delivered to the account, per recipient.
+	BytesReceived: number  // Total size of received messages.
+	MessagesSent: number  // Outgoing messages delivered to remote mail servers, per recipient.
+	BytesSent: number  // Total size of sent messages, i.e. outgoing bandwidth.
+	StoredBytes: number  // Highest sampled total size of messages in the account during the month.
+	Updated: Date
+}
+
+// Quarantined is an incoming message held in quarantine instead of being
+// rejected. The message file is stored separately, see package quarantine.
+export interface Quarantined {
+	ID: number
+	Received: Date
+	Expires: Date
+	Account: string
+	Mailbox: string  // Mailbox to deliver to when released.
+	Reason: string  // Reason the message would have been rejected, e.g. "hash-blocklisted".
+	RemoteIP: string
+	MailFrom: string  // SMTP MAIL FROM, empty for the null sender.
+	RcptTo: string  // SMTP RCPT TO, possibly an alias address.
+	MsgFrom: string  // Address in message From header.
+	Subject: string
+	MessageID: string
+	Size: number
+	Digested: Date  // When the account was sent a digest including this message. Zero if not yet.
+}
+
+// PasskeyCreationOptions are the parameters for registering a passkey with
+// navigator.credentials.create in the browser. Binary values are base64url
+// encoded.
+export interface PasskeyCreationOptions {
+	Challenge: string
+	RPID: string
+	RPName: string
+	UserID: string
+	UserName: string
+	UserDisplayName: string
+	ExcludeCredentialIDs?: string[] | null  // Already registered, to prevent registering an authenticator twice.
+	Algorithms?: number[] | null  // COSE algorithm identifiers.
+	Timeout: number  // In milliseconds.
+}
+
+// PasskeyAttestation is the response from navigator.credentials.create, with
+// base64url encoded values.
+export interface PasskeyAttestation {
+	ClientDataJSON: string
+	AttestationObject: string
+}
+
+// APIToken is a bearer token for the admin API. Only a hash of the token is
+// stored, the token itself is only returned when it is created.
+export interface APIToken {
+	ID: number
+	Created: Date
+	Name: string  // For recognizing the token, e.g. name of the system using it.
+	Role: Role
+	LastUsed: Date  // Updated at most once a minute.
+}
+
+// AuditEntry records an administrative action, e.g. a configuration change,
+// password reset or queue operation. Entries are only ever added, never removed.
+// References to an account are redacted when the account is purged, see
+// AuditRedact.
+export interface AuditEntry {
+	ID: number
+	Time: Date
+	Source: string  // "webadmin", "adminapi" or "ctl".
+	Actor: string  // Name of API token for adminapi, login for webadmin. Empty for ctl.
+	RemoteIP: string  // Empty for ctl.
+	Action: string  // Name of API method or ctl command, e.g. "AccountAdd".
+	Params: string  // Parameters as JSON (for ctl: command-line arguments), with secrets like passwords replaced by "***".
+	Error: string  // Empty if the action succeeded.
+}
+
 // ClientConfigs holds the client configuration for IMAP/Submission for a
 // domain.
 export interface ClientConfigs {
@@ -682,6 +1007,7 @@ export interface Msg {
 	BaseID: number  // A message for multiple recipients will get a BaseID that is identical to the first Msg.ID queued. The message contents will be identical for each recipient, including MsgPrefix. If other properties are identical too, including recipient domain, multiple Msgs may be delivered in a single SMTP transaction. For messages with a single recipient, this field will be 0.
 	Queued: Date
 	Hold: boolean  // If set, delivery won't be attempted.
+	HoldUntil: Date  // If set, delivery won't be attempted before this time, and the sender can cancel the message until then, for "undo send".
 	SenderAccount: string  // Failures are delivered back to this local account. Also used for routing.
 	SenderLocalpart: Localpart  // Should be a local user and domain.
 	SenderDomain: IPDomain
@@ -709,6 +1035,9 @@ export interface Msg {
 	RequireTLS?: boolean | null  // RequireTLS influences TLS verification during delivery.  If nil, the recipient domain policy is followed (MTA-STS and/or DANE), falling back to optional opportunistic non-verified STARTTLS.  If RequireTLS is true (through SMTP REQUIRETLS extension or webmail submit), MTA-STS or DANE is required, as well as REQUIRETLS support by the next hop server.  If RequireTLS is false (through messag header "TLS-Required: No"), the recipient domain's policy is ignored if it does not lead to a successful TLS connection, i.e. falling back to SMTP delivery with unverified STARTTLS or plain text.
 	FutureReleaseRequest: string  // For DSNs, where the original FUTURERELEASE value must be included as per-message field. This field should be of the form "for;" plus interval, or "until;" plus utc date-time.
 	Extra?: { [key: string]: string }  // Extra information, for transactional email.
+	TraceParent: string  // W3C traceparent of the span that queued the message, e.g. the incoming SMTP transaction. Delivery attempts continue this trace, if tracing is enabled.
+	XClientAddr: string  // For messages received by a frontend node or secondary MX and forwarded to the storage node or primary, the remote IP and EHLO hostname of the original SMTP connection, passed on with XCLIENT. A frontend node does not send DSNs for failed deliveries of forwarded messages, its accounts are not used.
+	XClientHelo: string
 }
 
 // IPDomain is an ip address, a domain, or empty.
@@ -884,6 +1213,41 @@ export interface HookRetired {
 	KeepUntil: Date
 }
 
+// StaticReload describes the changes in the static config file compared to the
+// running configuration.
+export interface StaticReload {
+	Diff: string  // Changed lines since the file was loaded or last reloaded, prefixed with "-" or "+", with unchanged context lines prefixed with a space.
+	Changed?: string[] | null  // Changed fields that take effect without restart.
+	Restart?: string[] | null  // Fields changed since startup that only take effect after a restart, e.g. Listeners.
+	Applied: boolean  // Whether the changed fields were applied.
+}
+
+// LogFilter filters recent log entries. Empty fields match all entries.
+export interface LogFilter {
+	Account: string  // Matches the "account" attribute.
+	MessageID: string  // Matches the "messageid" attribute, with or without <>.
+	Cid: string  // Connection ID, in hex, matches the "cid" attribute.
+	Pkg: string  // Package that logged the entry.
+	Level: string  // Minimum level, e.g. "info".
+	Text: string  // Case-insensitive substring of message or attribute value.
+	Max: number  // Maximum number of entries returned. Default 1000.
+}
+
+// LogEntry is a recently logged line.
+export interface LogEntry {
+	Time: Date
+	Level: string
+	Pkg: string
+	Message: string
+	Fields?: LogField[] | null
+}
+
+// LogField is an attribute of a log entry.
+export interface LogField {
+	Key: string
+	Value: string
+}
+
 // WebserverConfig is the combination of WebDomainRedirects and WebHandlers
 // from the domains.conf configuration file.
 export interface WebserverConfig {
@@ -898,6 +1262,9 @@ export interface WebHandler {
 	PathRegexp: string
 	DontRedirectPlainHTTP: boolean
 	Compress: boolean
+	Access?: WebAccess | null
+	RateLimit?: WebRateLimit | null
+	Rules?: WebRule[] | null
 	WebStatic?: WebStatic | null
 	WebRedirect?: WebRedirect | null
 	WebForward?: WebForward | null
@@ -905,6 +1272,48 @@ export interface WebHandler {
 	DNSDomain: Domain
 }
 
+// WebAccess is access control for a WebHandler. Requests are first checked
+// against the IP lists, then authentication is required, if configured.
+export interface WebAccess {
+	IPAllow?: string[] | null
+	IPDeny?: string[] | null
+	BasicAuth?: WebBasicAuth | null
+	OIDC?: WebOIDCAuth | null
+}
+
+// WebBasicAuth is HTTP basic authentication for a WebHandler, with credentials
+// of accounts and/or from an htpasswd file.
+export interface WebBasicAuth {
+	Realm: string
+	AllAccounts: boolean
+	Accounts?: string[] | null
+	HTPasswdFile: string
+}
+
+// WebOIDCAuth is login through the OpenID Connect identity provider for a
+// WebHandler.
+export interface WebOIDCAuth {
+	Emails?: string[] | null
+	Domains?: string[] | null
+}
+
+// WebRateLimit limits the request rate for a WebHandler. Limits apply to the
+// remote IP, and to its networks, like for connections to SMTP and IMAP.
+export interface WebRateLimit {
+	Requests: number
+	Window: number
+}
+
+// WebRule blocks requests for a WebHandler, or limits the size of their request
+// bodies. A rule applies to requests that match all its conditions, a rule
+// without conditions applies to all requests.
+export interface WebRule {
+	PathRegexp: string
+	Methods?: string[] | null
+	UserAgentRegexp: string
+	MaxBodySize: number
+}
+
 export interface WebStatic {
 	StripPrefix: string
 	Root: string
@@ -924,6 +1333,21 @@ export interface WebForward {
 	StripPath: boolean
 	URL: string
 	ResponseHeaders?: { [key: string]: string }
+	HTTP2: boolean
+	DialTimeout: number
+	ResponseTimeout: number
+	RequestHeaders?: { [key: string]: string }
+	RequestHeadersRemove?: string[] | null
+	RequestHeaderRewrites?: WebHeaderRewrite[] | null
+	ResponseHeadersRemove?: string[] | null
+	ResponseHeaderRewrites?: WebHeaderRewrite[] | null
+}
+
+// WebHeaderRewrite replaces values of a header with a regular expression.
+export interface WebHeaderRewrite {
+	Header: string
+	ValueRegexp: string
+	Replace: string
 }
 
 // Transport is a method to delivery a message. At most one of the fields can
@@ -985,7 +1409,7 @@ export interface Evaluation {
 	Optional: boolean  // If optional, this evaluation is not a reason to send a DMARC report, but it will be included when a report is sent due to other non-optional evaluations. Set for evaluations of incoming DMARC reports. We don't want such deliveries causing us to send a report, or we would keep exchanging reporting messages forever. Also set for when evaluation is a DMARC reject for domains we haven't positively interacted with, to prevent being used to flood an unsuspecting domain with reports.
 	IntervalHours: number  // Effective aggregate reporting interval in hours. Between 1 and 24, rounded up from seconds from policy to first number that can divide 24.
 	Addresses?: string[] | null  // "rua" in DMARC record, we only store evaluations for records with aggregate reporting addresses, so always non-empty.
-	PolicyPublished: PolicyPublished  // Policy used for evaluation. We don't store the "fo" field for failure reporting options, since we don't send failure reports for individual messages.
+	PolicyPublished: PolicyPublished  // Policy used for evaluation. We don't store the "fo" field for failure reporting options, failure reports are sent for individual messages during delivery.
 	SourceIP: string  // For "row" in a report record.
 	Disposition: Disposition
 	AlignedDKIMPass: boolean
@@ -1042,10 +1466,67 @@ export interface Dynamic {
 	WebDomainRedirects?: { [key: string]: string }
 	WebHandlers?: WebHandler[] | null
 	Routes?: Route[] | null
+	AddressRewrites?: AddressRewrite[] | null
 	MonitorDNSBLs?: string[] | null
+	Version: number
 	MonitorDNSBLZones?: Domain[] | null
 }
 
+// Status is the promotion state of the MTA-STS policy of a domain.
+export interface Status {
+	Domain: string  // Unicode.
+	PolicyID: string  // Empty if domain has no MTA-STS policy.
+	Mode: Mode
+	EnforceAfter: number  // Zero if automatic promotion is not configured.
+	TestingStart: Date  // When the policy was first seen in mode testing with its current policy ID. Zero if not in mode testing or without EnforceAfter.
+	LastFailure: Date  // End of period of most recent TLS report with failures since TestingStart. Zero if none.
+	CleanReports: number  // Number of TLS reports without failures since TestingStart and LastFailure.
+	PromoteAt: Date  // When the policy will be promoted. Zero while no clean TLS report has been received.
+}
+
+// AdminScope describes what the logged in admin can manage.
+export interface AdminScope {
+	LoginAddress: string  // Empty for the server admin, the login address for a domain admin.
+	Domains?: Domain[] | null  // For domain admins, the domains they can manage.
+}
+
+// DomainSetup is a domain added with the add domain wizard of the admin web
+// interface, for which the DNS records have not yet been verified. Outgoing
+// messages for the domain are not DKIM-signed, and its MTA-STS policy stays in
+// mode testing, until the setup is activated.
+export interface DomainSetup {
+	ID: number
+	Created: Date
+	Domain: string  // Unicode.
+	Sign?: string[] | null  // DKIM selectors to sign with once activated.
+	MTASTSEnforce: boolean  // Whether to change the MTA-STS policy to mode enforce once activated.
+	LastCheck: Date  // Of DNS records. Zero if not yet checked.
+}
+
+// DomainSetupStatus is the state of a pending domain setup.
+export interface DomainSetupStatus {
+	Setup: DomainSetup
+	Records?: DomainSetupRecord[] | null
+	Checks?: DomainSetupCheck[] | null
+	Ready: boolean  // Whether all checks passed, and the setup can be activated.
+}
+
+// DomainSetupRecord is a DNS record to create for a domain.
+export interface DomainSetupRecord {
+	Name: string  // Absolute, with trailing dot.
+	Type: string  // E.g. "MX", "TXT", "CNAME".
+	Value: string  // Record data. For TXT, the text without quotes, with multiple strings joined.
+	Zone: string  // Record in zone file syntax.
+	Check: string  // Check that verifies the record: "MX", "SPF", "DKIM", "DMARC", "TLSRPT", "MTASTS". Empty for records that are not verified for the setup.
+}
+
+// DomainSetupCheck is the result of one of the DNS checks required for
+// activating a domain setup.
+export interface DomainSetupCheck {
+	Name: string  // "MX", "SPF", "DKIM", "DMARC", "TLSRPT", "MTASTS".
+	Errors?: string[] | null
+}
+
 export type CSRFToken = string
 
 // Policy as used in DMARC DNS record for "p=" or "sp=".
@@ -1166,6 +1647,28 @@ export enum SPFResult {
 	SPFPermerror = "permerror",
 }
 
+// EventKind is the kind of step in the handling of a message.
+export enum EventKind {
+	EventReceived = "received",  // Message received over SMTP, incoming or submitted.
+	EventRuleset = "ruleset",  // Ruleset of destination matched, selecting the mailbox.
+	EventJunk = "junk",  // Result of junk filter classification.
+	EventDelivered = "delivered",  // Delivered to a mailbox of an account, or to a remote server from the queue.
+	EventRejected = "rejected",  // Incoming message rejected for a recipient.
+	EventQuarantined = "quarantined",  // Incoming message held in quarantine instead of being rejected.
+	EventDiscarded = "discarded",  // Incoming message discarded, e.g. by a filter rule or for a spamtrap.
+	EventQueued = "queued",  // Added to the queue for delivery to a recipient.
+	EventAttempt = "attempt",  // Delivery attempt from the queue with a temporary failure, will be retried.
+	EventFailed = "failed",  // Delivery from the queue failed permanently, or message was dropped from the queue.
+}
+
+// Role of an API token, determining which admin API methods it can call.
+export enum Role {
+	RoleReadonly = "readonly",  // Only methods that don't make changes.
+	RoleQueue = "queue",  // Read-only methods, and managing the outgoing queue.
+	RoleDomains = "domains",  // Read-only methods, and managing domains, accounts, addresses and aliases.
+	RoleAdmin = "admin",  // All methods, including the audit log.
+}
+
 // An IP is a single IP address, a slice of bytes.
 // Functions in this package accept either 4-byte (IPv4)
 // or 16-byte (IPv6) slices as input.
@@ -1177,10 +1680,12 @@ export enum SPFResult {
 // be an IPv4 address.
 export type IP = string
 
-export const structTypes: {[typename: string]: boolean} = {"Account":true,"Address":true,"AddressAlias":true,"Alias":true,"AliasAddress":true,"AuthResults":true,"AutoconfCheckResult":true,"AutodiscoverCheckResult":true,"AutodiscoverSRV":true,"AutomaticJunkFlags":true,"Canonicalization":true,"CheckResult":true,"ClientConfigs":true,"ClientConfigsEntry":true,"ConfigDomain":true,"DANECheckResult":true,"DKIM":true,"DKIMAuthResult":true,"DKIMCheckResult":true,"DKIMRecord":true,"DMARC":true,"DMARCCheckResult":true,"DMARCRecord":true,"DMARCSummary":true,"DNSSECResult":true,"DateRange":true,"Destination":true,"Directive":true,"Domain":true,"DomainFeedback":true,"Dynamic":true,"Evaluation":true,"EvaluationStat":true,"Extension":true,"FailureDetails":true,"Filter":true,"HoldRule":true,"Hook":true,"HookFilter":true,"HookResult":true,"HookRetired":true,"HookRetiredFilter":true,"HookRetiredSort":true,"HookSort":true,"IPDomain":true,"IPRevCheckResult":true,"Identifiers":true,"IncomingWebhook":true,"JunkFilter":true,"MTASTS":true,"MTASTSCheckResult":true,"MTASTSRecord":true,"MX":true,"MXCheckResult":true,"Modifier":true,"Msg":true,"MsgResult":true,"MsgRetired":true,"OutgoingWebhook":true,"Pair":true,"Policy":true,"PolicyEvaluated":true,"PolicyOverrideReason":true,"PolicyPublished":true,"PolicyRecord":true,"Record":true,"Report":true,"ReportMetadata":true,"ReportRecord":true,"Result":true,"ResultPolicy":true,"RetiredFilter":true,"RetiredSort":true,"Reverse":true,"Route":true,"Row":true,"Ruleset":true,"SMTPAuth":true,"SPFAuthResult":true,"SPFCheckResult":true,"SPFRecord":true,"SRV":true,"SRVConfCheckResult":true,"STSMX":true,"Selector":true,"Sort":true,"SubjectPass":true,"Summary":true,"SuppressAddress":true,"TLSCheckResult":true,"TLSRPT":true,"TLSRPTCheckResult":true,"TLSRPTDateRange":true,"TLSRPTRecord":true,"TLSRPTSummary":true,"TLSRPTSuppressAddress":true,"TLSReportRecord":true,"TLSResult":true,"Transport":true,"TransportDirect":true,"TransportSMTP":true,"TransportSocks":true,"URI":true,"WebForward":true,"WebHandler":true,"WebRedirect":true,"WebStatic":true,"WebserverConfig":true}
-export const stringsTypes: {[typename: string]: boolean} = {"Align":true,"Alignment":true,"CSRFToken":true,"DKIMResult":true,"DMARCPolicy":true,"DMARCResult":true,"Disposition":true,"IP":true,"Localpart":true,"Mode":true,"PolicyOverride":true,"PolicyType":true,"RUA":true,"ResultType":true,"SPFDomainScope":true,"SPFResult":true}
+export const structTypes: {[typename: string]: boolean} = {"APIToken":true,"AbuseReport":true,"Account":true,"AccountDeletion":true,"Address":true,"AddressAlias":true,"AddressRewrite":true,"AdminScope":true,"Alias":true,"AliasAddress":true,"AuditEntry":true,"AuthResults":true,"AutoBanEntry":true,"AutoconfCheckResult":true,"AutodiscoverCheckResult":true,"AutodiscoverSRV":true,"AutomaticJunkFlags":true,"Canonicalization":true,"CertificateInfo":true,"CheckResult":true,"ClientConfigs":true,"ClientConfigsEntry":true,"ConfigDomain":true,"DANECheckResult":true,"DKIM":true,"DKIMAuthResult":true,"DKIMCheckResult":true,"DKIMRecord":true,"DMARC":true,"DMARCCheckResult":true,"DMARCRecord":true,"DMARCSummary":true,"DNSSECResult":true,"DateRange":true,"Destination":true,"Directive":true,"Domain":true,"DomainAuth":true,"DomainFeedback":true,"DomainSetup":true,"DomainSetupCheck":true,"DomainSetupRecord":true,"DomainSetupStatus":true,"Dynamic":true,"Evaluation":true,"EvaluationStat":true,"Extension":true,"FailureDetails":true,"Filter":true,"Footer":true,"HoldRule":true,"Hook":true,"HookFilter":true,"HookResult":true,"HookRetired":true,"HookRetiredFilter":true,"HookRetiredSort":true,"HookSort":true,"IPDomain":true,"IPRevCheckResult":true,"Identifiers":true,"IncomingWebhook":true,"JunkFilter":true,"LDAPAuth":true,"LineEndingSource":true,"LogEntry":true,"LogField":true,"LogFilter":true,"MTASTS":true,"MTASTSCheckResult":true,"MTASTSRecord":true,"MX":true,"MXCheckResult":true,"MessageEvent":true,"Modifier":true,"Msg":true,"MsgResult":true,"MsgRetired":true,"OutgoingWebhook":true,"PAMAuth":true,"Pair":true,"Passkey":true,"PasskeyAssertion":true,"PasskeyAttestation":true,"PasskeyCreationOptions":true,"PasskeyRequestOptions":true,"PasswordPolicy":true,"Policy":true,"PolicyEvaluated":true,"PolicyOverrideReason":true,"PolicyPublished":true,"PolicyRecord":true,"ProtocolSession":true,"Quarantined":true,"Record":true,"Report":true,"ReportMetadata":true,"ReportRecord":true,"Result":true,"ResultPolicy":true,"RetiredFilter":true,"RetiredSort":true,"Reverse":true,"Route":true,"Row":true,"Ruleset":true,"SMTPAuth":true,"SPFAuthResult":true,"SPFCheckResult":true,"SPFRecord":true,"SRV":true,"SRVConfCheckResult":true,"STSMX":true,"Selector":true,"Sort":true,"SpamtrapHit":true,"StaticReload":true,"Status":true,"SubjectPass":true,"SubmissionIncident":true,"Summary":true,"SuppressAddress":true,"TLSCheckResult":true,"TLSRPT":true,"TLSRPTCheckResult":true,"TLSRPTDateRange":true,"TLSRPTRecord":true,"TLSRPTSummary":true,"TLSRPTSuppressAddress":true,"TLSReportRecord":true,"TLSResult":true,"Transport":true,"TransportDirect":true,"TransportSMTP":true,"TransportSocks":true,"URI":true,"Usage":true,"WebAccess":true,"WebBasicAuth":true,"WebForward":true,"WebHandler":true,"WebHeaderRewrite":true,"WebOIDCAuth":true,"WebRateLimit":true,"WebRedirect":true,"WebRule":true,"WebStatic":true,"WebserverConfig":true}
+export const stringsTypes: {[typename: string]: boolean} = {"Align":true,"Alignment":true,"CSRFToken":true,"DKIMResult":true,"DMARCPolicy":true,"DMARCResult":true,"Disposition":true,"EventKind":true,"IP":true,"Localpart":true,"Mode":true,"PolicyOverride":true,"PolicyType":true,"RUA":true,"ResultType":true,"Role":true,"SPFDomainScope":true,"SPFResult":true}
 export const intsTypes: {[typename: string]: boolean} = {}
 export const types: TypenameMap = {
+	"PasskeyRequestOptions": {"Name":"PasskeyRequestOptions","Docs":"","Fields":[{"Name":"Challenge","Docs":"","Typewords":["string"]},{"Name":"RPID","Docs":"","Typewords":["string"]},{"Name":"Timeout","Docs":"","Typewords":["int32"]}]},
+	"PasskeyAssertion": {"Name":"PasskeyAssertion","Docs":"","Fields":[{"Name":"CredentialID","Docs":"","Typewords":["string"]},{"Name":"ClientDataJSON","Docs":"","Typewords":["string"]},{"Name":"AuthenticatorData","Docs":"","Typewords":["string"]},{"Name":"Signature","Docs":"","Typewords":["string"]},{"Name":"UserHandle","Docs":"","Typewords":["string"]}]},
 	"CheckResult": {"Name":"CheckResult","Docs":"","Fields":[{"Name":"Domain","Docs":"","Typewords":["string"]},{"Name":"DNSSEC","Docs":"","Typewords":["DNSSECResult"]},{"Name":"IPRev","Docs":"","Typewords":["IPRevCheckResult"]},{"Name":"MX","Docs":"","Typewords":["MXCheckResult"]},{"Name":"TLS","Docs":"","Typewords":["TLSCheckResult"]},{"Name":"DANE","Docs":"","Typewords":["DANECheckResult"]},{"Name":"SPF","Docs":"","Typewords":["SPFCheckResult"]},{"Name":"DKIM","Docs":"","Typewords":["DKIMCheckResult"]},{"Name":"DMARC","Docs":"","Typewords":["DMARCCheckResult"]},{"Name":"HostTLSRPT","Docs":"","Typewords":["TLSRPTCheckResult"]},{"Name":"DomainTLSRPT","Docs":"","Typewords":["TLSRPTCheckResult"]},{"Name":"MTASTS","Docs":"","Typewords":["MTASTSCheckResult"]},{"Name":"SRVConf","Docs":"","Typewords":["SRVConfCheckResult"]},{"Name":"Autoconf","Docs":"","Typewords":["AutoconfCheckResult"]},{"Name":"Autodiscover","Docs":"","Typewords":["AutodiscoverCheckResult"]}]},
 	"DNSSECResult": {"Name":"DNSSECResult","Docs":"","Fields":[{"Name":"Errors","Docs":"","Typewords":["[]","string"]},{"Name":"Warnings","Docs":"","Typewords":["[]","string"]},{"Name":"Instructions","Docs":"","Typewords":["[]","string"]}]},
 	"IPRevCheckResult": {"Name":"IPRevCheckResult","Docs":"","Fields":[{"Name":"Hostname","Docs":"","Typewords":["Domain"]},{"Name":"IPNames","Docs":"","Typewords":["{}","[]","string"]},{"Name":"Errors","Docs":"","Typewords":["[]","string"]},{"Name":"Warnings","Docs":"","Typewords":["[]","string"]},{"Name":"Instructions","Docs":"","Typewords":["[]","string"]}]},
@@ -1212,27 +1717,34 @@ export const types: TypenameMap = {
 	"AutoconfCheckResult": {"Name":"AutoconfCheckResult","Docs":"","Fields":[{"Name":"ClientSettingsDomainIPs","Docs":"","Typewords":["[]","string"]},{"Name":"IPs","Docs":"","Typewords":["[]","string"]},{"Name":"Errors","Docs":"","Typewords":["[]","string"]},{"Name":"Warnings","Docs":"","Typewords":["[]","string"]},{"Name":"Instructions","Docs":"","Typewords":["[]","string"]}]},
 	"AutodiscoverCheckResult": {"Name":"AutodiscoverCheckResult","Docs":"","Fields":[{"Name":"Records","Docs":"","Typewords":["[]","AutodiscoverSRV"]},{"Name":"Errors","Docs":"","Typewords":["[]","string"]},{"Name":"Warnings","Docs":"","Typewords":["[]","string"]},{"Name":"Instructions","Docs":"","Typewords":["[]","string"]}]},
 	"AutodiscoverSRV": {"Name":"AutodiscoverSRV","Docs":"","Fields":[{"Name":"Target","Docs":"","Typewords":["string"]},{"Name":"Port","Docs":"","Typewords":["uint16"]},{"Name":"Priority","Docs":"","Typewords":["uint16"]},{"Name":"Weight","Docs":"","Typewords":["uint16"]},{"Name":"IPs","Docs":"","Typewords":["[]","string"]}]},
-	"ConfigDomain": {"Name":"ConfigDomain","Docs":"","Fields":[{"Name":"Description","Docs":"","Typewords":["string"]},{"Name":"ClientSettingsDomain","Docs":"","Typewords":["string"]},{"Name":"LocalpartCatchallSeparator","Docs":"","Typewords":["string"]},{"Name":"LocalpartCaseSensitive","Docs":"","Typewords":["bool"]},{"Name":"DKIM","Docs":"","Typewords":["DKIM"]},{"Name":"DMARC","Docs":"","Typewords":["nullable","DMARC"]},{"Name":"MTASTS","Docs":"","Typewords":["nullable","MTASTS"]},{"Name":"TLSRPT","Docs":"","Typewords":["nullable","TLSRPT"]},{"Name":"Routes","Docs":"","Typewords":["[]","Route"]},{"Name":"Aliases","Docs":"","Typewords":["{}","Alias"]},{"Name":"Domain","Docs":"","Typewords":["Domain"]}]},
+	"ConfigDomain": {"Name":"ConfigDomain","Docs":"","Fields":[{"Name":"Description","Docs":"","Typewords":["string"]},{"Name":"ClientSettingsDomain","Docs":"","Typewords":["string"]},{"Name":"LocalpartCatchallSeparator","Docs":"","Typewords":["string"]},{"Name":"LocalpartCaseSensitive","Docs":"","Typewords":["bool"]},{"Name":"DKIM","Docs":"","Typewords":["DKIM"]},{"Name":"DMARC","Docs":"","Typewords":["nullable","DMARC"]},{"Name":"MTASTS","Docs":"","Typewords":["nullable","MTASTS"]},{"Name":"TLSRPT","Docs":"","Typewords":["nullable","TLSRPT"]},{"Name":"Routes","Docs":"","Typewords":["[]","Route"]},{"Name":"Aliases","Docs":"","Typewords":["{}","Alias"]},{"Name":"RequireTOTP","Docs":"","Typewords":["bool"]},{"Name":"Admins","Docs":"","Typewords":["[]","string"]},{"Name":"SenderAllow","Docs":"","Typewords":["[]","string"]},{"Name":"SenderReject","Docs":"","Typewords":["[]","string"]},{"Name":"SenderJunk","Docs":"","Typewords":["[]","string"]},{"Name":"Spamtraps","Docs":"","Typewords":["[]","string"]},{"Name":"Auth","Docs":"","Typewords":["nullable","DomainAuth"]},{"Name":"Footer","Docs":"","Typewords":["nullable","Footer"]},{"Name":"AddressRewrites","Docs":"","Typewords":["[]","AddressRewrite"]},{"Name":"Domain","Docs":"","Typewords":["Domain"]}]},
 	"DKIM": {"Name":"DKIM","Docs":"","Fields":[{"Name":"Selectors","Docs":"","Typewords":["{}","Selector"]},{"Name":"Sign","Docs":"","Typewords":["[]","string"]}]},
 	"Selector": {"Name":"Selector","Docs":"","Fields":[{"Name":"Hash","Docs":"","Typewords":["string"]},{"Name":"HashEffective","Docs":"","Typewords":["string"]},{"Name":"Canonicalization","Docs":"","Typewords":["Canonicalization"]},{"Name":"Headers","Docs":"","Typewords":["[]","string"]},{"Name":"HeadersEffective","Docs":"","Typewords":["[]","string"]},{"Name":"DontSealHeaders","Docs":"","Typewords":["bool"]},{"Name":"Expiration","Docs":"","Typewords":["string"]},{"Name":"PrivateKeyFile","Docs":"","Typewords":["string"]},{"Name":"Algorithm","Docs":"","Typewords":["string"]}]},
 	"Canonicalization": {"Name":"Canonicalization","Docs":"","Fields":[{"Name":"HeaderRelaxed","Docs":"","Typewords":["bool"]},{"Name":"BodyRelaxed","Docs":"","Typewords":["bool"]}]},
 	"DMARC": {"Name":"DMARC","Docs":"","Fields":[{"Name":"Localpart","Docs":"","Typewords":["string"]},{"Name":"Domain","Docs":"","Typewords":["string"]},{"Name":"Account","Docs":"","Typewords":["string"]},{"Name":"Mailbox","Docs":"","Typewords":["string"]},{"Name":"ParsedLocalpart","Docs":"","Typewords":["Localpart"]},{"Name":"DNSDomain","Docs":"","Typewords":["Domain"]}]},
-	"MTASTS": {"Name":"MTASTS","Docs":"","Fields":[{"Name":"PolicyID","Docs":"","Typewords":["string"]},{"Name":"Mode","Docs":"","Typewords":["Mode"]},{"Name":"MaxAge","Docs":"","Typewords":["int64"]},{"Name":"MX","Docs":"","Typewords":["[]","string"]}]},
+	"MTASTS": {"Name":"MTASTS","Docs":"","Fields":[{"Name":"PolicyID","Docs":"","Typewords":["string"]},{"Name":"Mode","Docs":"","Typewords":["Mode"]},{"Name":"MaxAge","Docs":"","Typewords":["int64"]},{"Name":"MX","Docs":"","Typewords":["[]","string"]},{"Name":"EnforceAfter","Docs":"","Typewords":["int64"]}]},
 	"TLSRPT": {"Name":"TLSRPT","Docs":"","Fields":[{"Name":"Localpart","Docs":"","Typewords":["string"]},{"Name":"Domain","Docs":"","Typewords":["string"]},{"Name":"Account","Docs":"","Typewords":["string"]},{"Name":"Mailbox","Docs":"","Typewords":["string"]},{"Name":"ParsedLocalpart","Docs":"","Typewords":["Localpart"]},{"Name":"DNSDomain","Docs":"","Typewords":["Domain"]}]},
 	"Route": {"Name":"Route","Docs":"","Fields":[{"Name":"FromDomain","Docs":"","Typewords":["[]","string"]},{"Name":"ToDomain","Docs":"","Typewords":["[]","string"]},{"Name":"MinimumAttempts","Docs":"","Typewords":["int32"]},{"Name":"Transport","Docs":"","Typewords":["string"]},{"Name":"FromDomainASCII","Docs":"","Typewords":["[]","string"]},{"Name":"ToDomainASCII","Docs":"","Typewords":["[]","string"]}]},
-	"Alias": {"Name":"Alias","Docs":"","Fields":[{"Name":"Addresses","Docs":"","Typewords":["[]","string"]},{"Name":"PostPublic","Docs":"","Typewords":["bool"]},{"Name":"ListMembers","Docs":"","Typewords":["bool"]},{"Name":"AllowMsgFrom","Docs":"","Typewords":["bool"]},{"Name":"LocalpartStr","Docs":"","Typewords":["string"]},{"Name":"Domain","Docs":"","Typewords":["Domain"]},{"Name":"ParsedAddresses","Docs":"","Typewords":["[]","AliasAddress"]}]},
+	"Alias": {"Name":"Alias","Docs":"","Fields":[{"Name":"Addresses","Docs":"","Typewords":["[]","string"]},{"Name":"PostPublic","Docs":"","Typewords":["bool"]},{"Name":"ListMembers","Docs":"","Typewords":["bool"]},{"Name":"AllowMsgFrom","Docs":"","Typewords":["bool"]},{"Name":"Owner","Docs":"","Typewords":["string"]},{"Name":"OwnerAddress","Docs":"","Typewords":["string"]},{"Name":"Moderated","Docs":"","Typewords":["bool"]},{"Name":"LocalpartStr","Docs":"","Typewords":["string"]},{"Name":"Domain","Docs":"","Typewords":["Domain"]},{"Name":"ParsedAddresses","Docs":"","Typewords":["[]","AliasAddress"]},{"Name":"ExternalAddresses","Docs":"","Typewords":["[]","Address"]},{"Name":"ParsedOwner","Docs":"","Typewords":["AliasAddress"]}]},
 	"AliasAddress": {"Name":"AliasAddress","Docs":"","Fields":[{"Name":"Address","Docs":"","Typewords":["Address"]},{"Name":"AccountName","Docs":"","Typewords":["string"]},{"Name":"Destination","Docs":"","Typewords":["Destination"]}]},
 	"Address": {"Name":"Address","Docs":"","Fields":[{"Name":"Localpart","Docs":"","Typewords":["Localpart"]},{"Name":"Domain","Docs":"","Typewords":["Domain"]}]},
 	"Destination": {"Name":"Destination","Docs":"","Fields":[{"Name":"Mailbox","Docs":"","Typewords":["string"]},{"Name":"Rulesets","Docs":"","Typewords":["[]","Ruleset"]},{"Name":"FullName","Docs":"","Typewords":["string"]}]},
 	"Ruleset": {"Name":"Ruleset","Docs":"","Fields":[{"Name":"SMTPMailFromRegexp","Docs":"","Typewords":["string"]},{"Name":"MsgFromRegexp","Docs":"","Typewords":["string"]},{"Name":"VerifiedDomain","Docs":"","Typewords":["string"]},{"Name":"HeadersRegexp","Docs":"","Typewords":["{}","string"]},{"Name":"IsForward","Docs":"","Typewords":["bool"]},{"Name":"ListAllowDomain","Docs":"","Typewords":["string"]},{"Name":"AcceptRejectsToMailbox","Docs":"","Typewords":["string"]},{"Name":"Mailbox","Docs":"","Typewords":["string"]},{"Name":"Comment","Docs":"","Typewords":["string"]},{"Name":"VerifiedDNSDomain","Docs":"","Typewords":["Domain"]},{"Name":"ListAllowDNSDomain","Docs":"","Typewords":["Domain"]}]},
-	"Account": {"Name":"Account","Docs":"","Fields":[{"Name":"OutgoingWebhook","Docs":"","Typewords":["nullable","OutgoingWebhook"]},{"Name":"IncomingWebhook","Docs":"","Typewords":["nullable","IncomingWebhook"]},{"Name":"FromIDLoginAddresses","Docs":"","Typewords":["[]","string"]},{"Name":"KeepRetiredMessagePeriod","Docs":"","Typewords":["int64"]},{"Name":"KeepRetiredWebhookPeriod","Docs":"","Typewords":["int64"]},{"Name":"Domain","Docs":"","Typewords":["string"]},{"Name":"Description","Docs":"","Typewords":["string"]},{"Name":"FullName","Docs":"","Typewords":["string"]},{"Name":"Destinations","Docs":"","Typewords":["{}","Destination"]},{"Name":"SubjectPass","Docs":"","Typewords":["SubjectPass"]},{"Name":"QuotaMessageSize","Docs":"","Typewords":["int64"]},{"Name":"RejectsMailbox","Docs":"","Typewords":["string"]},{"Name":"KeepRejects","Docs":"","Typewords":["bool"]},{"Name":"AutomaticJunkFlags","Docs":"","Typewords":["AutomaticJunkFlags"]},{"Name":"JunkFilter","Docs":"","Typewords":["nullable","JunkFilter"]},{"Name":"MaxOutgoingMessagesPerDay","Docs":"","Typewords":["int32"]},{"Name":"MaxFirstTimeRecipientsPerDay","Docs":"","Typewords":["int32"]},{"Name":"NoFirstTimeSenderDelay","Docs":"","Typewords":["bool"]},{"Name":"Routes","Docs":"","Typewords":["[]","Route"]},{"Name":"DNSDomain","Docs":"","Typewords":["Domain"]},{"Name":"Aliases","Docs":"","Typewords":["[]","AddressAlias"]}]},
+	"DomainAuth": {"Name":"DomainAuth","Docs":"","Fields":[{"Name":"LDAP","Docs":"","Typewords":["nullable","LDAPAuth"]},{"Name":"PAM","Docs":"","Typewords":["nullable","PAMAuth"]},{"Name":"UsernameEmail","Docs":"","Typewords":["bool"]},{"Name":"AutoProvision","Docs":"","Typewords":["bool"]}]},
+	"LDAPAuth": {"Name":"LDAPAuth","Docs":"","Fields":[{"Name":"URL","Docs":"","Typewords":["string"]},{"Name":"StartTLS","Docs":"","Typewords":["bool"]},{"Name":"UserDNTemplate","Docs":"","Typewords":["string"]},{"Name":"BindDN","Docs":"","Typewords":["string"]},{"Name":"BindPassword","Docs":"","Typewords":["string"]},{"Name":"BaseDN","Docs":"","Typewords":["string"]},{"Name":"Filter","Docs":"","Typewords":["string"]}]},
+	"PAMAuth": {"Name":"PAMAuth","Docs":"","Fields":[{"Name":"Service","Docs":"","Typewords":["string"]}]},
+	"Footer": {"Name":"Footer","Docs":"","Fields":[{"Name":"Text","Docs":"","Typewords":["[]","string"]},{"Name":"HTML","Docs":"","Typewords":["[]","string"]},{"Name":"SkipReplies","Docs":"","Typewords":["bool"]}]},
+	"AddressRewrite": {"Name":"AddressRewrite","Docs":"","Fields":[{"Name":"AddressRegexp","Docs":"","Typewords":["string"]},{"Name":"Replace","Docs":"","Typewords":["string"]},{"Name":"Priority","Docs":"","Typewords":["int32"]},{"Name":"Sender","Docs":"","Typewords":["bool"]},{"Name":"Recipient","Docs":"","Typewords":["bool"]}]},
+	"Account": {"Name":"Account","Docs":"","Fields":[{"Name":"OutgoingWebhook","Docs":"","Typewords":["nullable","OutgoingWebhook"]},{"Name":"IncomingWebhook","Docs":"","Typewords":["nullable","IncomingWebhook"]},{"Name":"FromIDLoginAddresses","Docs":"","Typewords":["[]","string"]},{"Name":"KeepRetiredMessagePeriod","Docs":"","Typewords":["int64"]},{"Name":"KeepRetiredWebhookPeriod","Docs":"","Typewords":["int64"]},{"Name":"Domain","Docs":"","Typewords":["string"]},{"Name":"Description","Docs":"","Typewords":["string"]},{"Name":"FullName","Docs":"","Typewords":["string"]},{"Name":"Destinations","Docs":"","Typewords":["{}","Destination"]},{"Name":"SubjectPass","Docs":"","Typewords":["SubjectPass"]},{"Name":"QuotaMessageSize","Docs":"","Typewords":["int64"]},{"Name":"CompressMessages","Docs":"","Typewords":["bool"]},{"Name":"RejectsMailbox","Docs":"","Typewords":["string"]},{"Name":"KeepRejects","Docs":"","Typewords":["bool"]},{"Name":"AutomaticJunkFlags","Docs":"","Typewords":["AutomaticJunkFlags"]},{"Name":"JunkFilter","Docs":"","Typewords":["nullable","JunkFilter"]},{"Name":"MaxOutgoingMessagesPerDay","Docs":"","Typewords":["int32"]},{"Name":"MaxFirstTimeRecipientsPerDay","Docs":"","Typewords":["int32"]},{"Name":"MaxAliases","Docs":"","Typewords":["int32"]},{"Name":"NoFirstTimeSenderDelay","Docs":"","Typewords":["bool"]},{"Name":"RequireTOTP","Docs":"","Typewords":["bool"]},{"Name":"LoginDisabled","Docs":"","Typewords":["string"]},{"Name":"Routes","Docs":"","Typewords":["[]","Route"]},{"Name":"Footer","Docs":"","Typewords":["nullable","Footer"]},{"Name":"PasswordPolicy","Docs":"","Typewords":["nullable","PasswordPolicy"]},{"Name":"DNSDomain","Docs":"","Typewords":["Domain"]},{"Name":"Aliases","Docs":"","Typewords":["[]","AddressAlias"]}]},
 	"OutgoingWebhook": {"Name":"OutgoingWebhook","Docs":"","Fields":[{"Name":"URL","Docs":"","Typewords":["string"]},{"Name":"Authorization","Docs":"","Typewords":["string"]},{"Name":"Events","Docs":"","Typewords":["[]","string"]}]},
 	"IncomingWebhook": {"Name":"IncomingWebhook","Docs":"","Fields":[{"Name":"URL","Docs":"","Typewords":["string"]},{"Name":"Authorization","Docs":"","Typewords":["string"]}]},
 	"SubjectPass": {"Name":"SubjectPass","Docs":"","Fields":[{"Name":"Period","Docs":"","Typewords":["int64"]}]},
 	"AutomaticJunkFlags": {"Name":"AutomaticJunkFlags","Docs":"","Fields":[{"Name":"Enabled","Docs":"","Typewords":["bool"]},{"Name":"JunkMailboxRegexp","Docs":"","Typewords":["string"]},{"Name":"NeutralMailboxRegexp","Docs":"","Typewords":["string"]},{"Name":"NotJunkMailboxRegexp","Docs":"","Typewords":["string"]}]},
-	"JunkFilter": {"Name":"JunkFilter","Docs":"","Fields":[{"Name":"Threshold","Docs":"","Typewords":["float64"]},{"Name":"Onegrams","Docs":"","Typewords":["bool"]},{"Name":"Twograms","Docs":"","Typewords":["bool"]},{"Name":"Threegrams","Docs":"","Typewords":["bool"]},{"Name":"MaxPower","Docs":"","Typewords":["float64"]},{"Name":"TopWords","Docs":"","Typewords":["int32"]},{"Name":"IgnoreWords","Docs":"","Typewords":["float64"]},{"Name":"RareWords","Docs":"","Typewords":["int32"]}]},
+	"JunkFilter": {"Name":"JunkFilter","Docs":"","Fields":[{"Name":"Threshold","Docs":"","Typewords":["float64"]},{"Name":"SharedWeight","Docs":"","Typewords":["float64"]},{"Name":"ContributeShared","Docs":"","Typewords":["bool"]},{"Name":"DelayFlagTraining","Docs":"","Typewords":["bool"]},{"Name":"Onegrams","Docs":"","Typewords":["bool"]},{"Name":"Twograms","Docs":"","Typewords":["bool"]},{"Name":"Threegrams","Docs":"","Typewords":["bool"]},{"Name":"MaxPower","Docs":"","Typewords":["float64"]},{"Name":"TopWords","Docs":"","Typewords":["int32"]},{"Name":"IgnoreWords","Docs":"","Typewords":["float64"]},{"Name":"RareWords","Docs":"","Typewords":["int32"]}]},
+	"PasswordPolicy": {"Name":"PasswordPolicy","Docs":"","Fields":[{"Name":"MinLength","Docs":"","Typewords":["int32"]},{"Name":"MinEntropy","Docs":"","Typewords":["int32"]},{"Name":"DenyList","Docs":"","Typewords":["[]","string"]},{"Name":"DenyListFile","Docs":"","Typewords":["string"]},{"Name":"BreachCheck","Docs":"","Typewords":["bool"]},{"Name":"BreachCheckURL","Docs":"","Typewords":["string"]}]},
 	"AddressAlias": {"Name":"AddressAlias","Docs":"","Fields":[{"Name":"SubscriptionAddress","Docs":"","Typewords":["string"]},{"Name":"Alias","Docs":"","Typewords":["Alias"]},{"Name":"MemberAddresses","Docs":"","Typewords":["[]","string"]}]},
 	"PolicyRecord": {"Name":"PolicyRecord","Docs":"","Fields":[{"Name":"Domain","Docs":"","Typewords":["string"]},{"Name":"Inserted","Docs":"","Typewords":["timestamp"]},{"Name":"ValidEnd","Docs":"","Typewords":["timestamp"]},{"Name":"LastUpdate","Docs":"","Typewords":["timestamp"]},{"Name":"LastUse","Docs":"","Typewords":["timestamp"]},{"Name":"Backoff","Docs":"","Typewords":["bool"]},{"Name":"RecordID","Docs":"","Typewords":["string"]},{"Name":"Version","Docs":"","Typewords":["string"]},{"Name":"Mode","Docs":"","Typewords":["Mode"]},{"Name":"MX","Docs":"","Typewords":["[]","STSMX"]},{"Name":"MaxAgeSeconds","Docs":"","Typewords":["int32"]},{"Name":"Extensions","Docs":"","Typewords":["[]","Pair"]},{"Name":"PolicyText","Docs":"","Typewords":["string"]}]},
+	"CertificateInfo": {"Name":"CertificateInfo","Docs":"","Fields":[{"Name":"Host","Docs":"","Typewords":["string"]},{"Name":"Provider","Docs":"","Typewords":["string"]},{"Name":"IssuerOrganization","Docs":"","Typewords":["string"]},{"Name":"IssuerCommonName","Docs":"","Typewords":["string"]},{"Name":"SerialNumber","Docs":"","Typewords":["string"]},{"Name":"NotBefore","Docs":"","Typewords":["timestamp"]},{"Name":"NotAfter","Docs":"","Typewords":["timestamp"]},{"Name":"DNS01","Docs":"","Typewords":["bool"]},{"Name":"Active","Docs":"","Typewords":["bool"]},{"Name":"RenewalStart","Docs":"","Typewords":["timestamp"]},{"Name":"RenewalEnd","Docs":"","Typewords":["timestamp"]}]},
 	"TLSReportRecord": {"Name":"TLSReportRecord","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Domain","Docs":"","Typewords":["string"]},{"Name":"FromDomain","Docs":"","Typewords":["string"]},{"Name":"MailFrom","Docs":"","Typewords":["string"]},{"Name":"HostReport","Docs":"","Typewords":["bool"]},{"Name":"Report","Docs":"","Typewords":["Report"]}]},
 	"Report": {"Name":"Report","Docs":"","Fields":[{"Name":"OrganizationName","Docs":"","Typewords":["string"]},{"Name":"DateRange","Docs":"","Typewords":["TLSRPTDateRange"]},{"Name":"ContactInfo","Docs":"","Typewords":["string"]},{"Name":"ReportID","Docs":"","Typewords":["string"]},{"Name":"Policies","Docs":"","Typewords":["[]","Result"]}]},
 	"TLSRPTDateRange": {"Name":"TLSRPTDateRange","Docs":"","Fields":[{"Name":"Start","Docs":"","Typewords":["timestamp"]},{"Name":"End","Docs":"","Typewords":["timestamp"]}]},
@@ -1255,12 +1767,27 @@ export const types: TypenameMap = {
 	"SPFAuthResult": {"Name":"SPFAuthResult","Docs":"","Fields":[{"Name":"Domain","Docs":"","Typewords":["string"]},{"Name":"Scope","Docs":"","Typewords":["SPFDomainScope"]},{"Name":"Result","Docs":"","Typewords":["SPFResult"]}]},
 	"DMARCSummary": {"Name":"DMARCSummary","Docs":"","Fields":[{"Name":"Domain","Docs":"","Typewords":["string"]},{"Name":"Total","Docs":"","Typewords":["int32"]},{"Name":"DispositionNone","Docs":"","Typewords":["int32"]},{"Name":"DispositionQuarantine","Docs":"","Typewords":["int32"]},{"Name":"DispositionReject","Docs":"","Typewords":["int32"]},{"Name":"DKIMFail","Docs":"","Typewords":["int32"]},{"Name":"SPFFail","Docs":"","Typewords":["int32"]},{"Name":"PolicyOverrides","Docs":"","Typewords":["{}","int32"]}]},
 	"Reverse": {"Name":"Reverse","Docs":"","Fields":[{"Name":"Hostnames","Docs":"","Typewords":["[]","string"]}]},
+	"ProtocolSession": {"Name":"ProtocolSession","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Protocol","Docs":"","Typewords":["string"]},{"Name":"LoginAddress","Docs":"","Typewords":["string"]},{"Name":"RemoteIP","Docs":"","Typewords":["string"]},{"Name":"ClientID","Docs":"","Typewords":["string"]},{"Name":"Started","Docs":"","Typewords":["timestamp"]},{"Name":"LastActivity","Docs":"","Typewords":["timestamp"]},{"Name":"Ended","Docs":"","Typewords":["timestamp"]},{"Name":"Active","Docs":"","Typewords":["bool"]},{"Name":"Closed","Docs":"","Typewords":["bool"]}]},
+	"Passkey": {"Name":"Passkey","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Label","Docs":"","Typewords":["string"]},{"Name":"LoginAddress","Docs":"","Typewords":["string"]},{"Name":"Created","Docs":"","Typewords":["timestamp"]},{"Name":"LastUsed","Docs":"","Typewords":["timestamp"]}]},
+	"AccountDeletion": {"Name":"AccountDeletion","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Account","Docs":"","Typewords":["string"]},{"Name":"Requested","Docs":"","Typewords":["timestamp"]},{"Name":"PurgeAfter","Docs":"","Typewords":["timestamp"]},{"Name":"RequestedBy","Docs":"","Typewords":["string"]},{"Name":"Addresses","Docs":"","Typewords":["[]","string"]}]},
+	"SubmissionIncident": {"Name":"SubmissionIncident","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Time","Docs":"","Typewords":["timestamp"]},{"Name":"Account","Docs":"","Typewords":["string"]},{"Name":"Source","Docs":"","Typewords":["string"]},{"Name":"RemoteIP","Docs":"","Typewords":["string"]},{"Name":"Anomalies","Docs":"","Typewords":["[]","string"]},{"Name":"Action","Docs":"","Typewords":["string"]},{"Name":"Until","Docs":"","Typewords":["timestamp"]},{"Name":"Cleared","Docs":"","Typewords":["bool"]}]},
+	"SpamtrapHit": {"Name":"SpamtrapHit","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Time","Docs":"","Typewords":["timestamp"]},{"Name":"Trap","Docs":"","Typewords":["string"]},{"Name":"RemoteIP","Docs":"","Typewords":["string"]},{"Name":"RemoteNetwork","Docs":"","Typewords":["string"]},{"Name":"EHLO","Docs":"","Typewords":["string"]},{"Name":"MailFrom","Docs":"","Typewords":["string"]},{"Name":"Domain","Docs":"","Typewords":["string"]},{"Name":"MsgFrom","Docs":"","Typewords":["string"]},{"Name":"Subject","Docs":"","Typewords":["string"]},{"Name":"Size","Docs":"","Typewords":["int64"]}]},
+	"AbuseReport": {"Name":"AbuseReport","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Time","Docs":"","Typewords":["timestamp"]},{"Name":"Rcpt","Docs":"","Typewords":["string"]},{"Name":"ReporterFrom","Docs":"","Typewords":["string"]},{"Name":"FeedbackType","Docs":"","Typewords":["string"]},{"Name":"UserAgent","Docs":"","Typewords":["string"]},{"Name":"SourceIP","Docs":"","Typewords":["string"]},{"Name":"ArrivalDate","Docs":"","Typewords":["timestamp"]},{"Name":"Incidents","Docs":"","Typewords":["int32"]},{"Name":"MessageID","Docs":"","Typewords":["string"]},{"Name":"MailFrom","Docs":"","Typewords":["string"]},{"Name":"RcptTo","Docs":"","Typewords":["string"]},{"Name":"From","Docs":"","Typewords":["string"]},{"Name":"Subject","Docs":"","Typewords":["string"]},{"Name":"Account","Docs":"","Typewords":["string"]},{"Name":"QueueIDs","Docs":"","Typewords":["[]","int64"]},{"Name":"Recipients","Docs":"","Typewords":["[]","string"]},{"Name":"Suppressed","Docs":"","Typewords":["[]","string"]},{"Name":"Handled","Docs":"","Typewords":["bool"]}]},
+	"LineEndingSource": {"Name":"LineEndingSource","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"RemoteIP","Docs":"","Typewords":["string"]},{"Name":"EHLO","Docs":"","Typewords":["string"]},{"Name":"MailFromDomain","Docs":"","Typewords":["string"]},{"Name":"Submission","Docs":"","Typewords":["bool"]},{"Name":"Messages","Docs":"","Typewords":["int64"]},{"Name":"BareCR","Docs":"","Typewords":["int64"]},{"Name":"BareLF","Docs":"","Typewords":["int64"]},{"Name":"BadDataEnd","Docs":"","Typewords":["int64"]},{"Name":"Rejected","Docs":"","Typewords":["int64"]},{"Name":"First","Docs":"","Typewords":["timestamp"]},{"Name":"Last","Docs":"","Typewords":["timestamp"]}]},
+	"AutoBanEntry": {"Name":"AutoBanEntry","Docs":"","Fields":[{"Name":"Network","Docs":"","Typewords":["string"]},{"Name":"Reason","Docs":"","Typewords":["string"]},{"Name":"Failures","Docs":"","Typewords":["int32"]},{"Name":"Start","Docs":"","Typewords":["timestamp"]},{"Name":"End","Docs":"","Typewords":["timestamp"]},{"Name":"Refused","Docs":"","Typewords":["int64"]}]},
+	"MessageEvent": {"Name":"MessageEvent","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Time","Docs":"","Typewords":["timestamp"]},{"Name":"MessageID","Docs":"","Typewords":["string"]},{"Name":"QueueID","Docs":"","Typewords":["int64"]},{"Name":"Cid","Docs":"","Typewords":["int64"]},{"Name":"Kind","Docs":"","Typewords":["EventKind"]},{"Name":"Account","Docs":"","Typewords":["string"]},{"Name":"Recipient","Docs":"","Typewords":["string"]},{"Name":"Remote","Docs":"","Typewords":["string"]},{"Name":"Result","Docs":"","Typewords":["string"]},{"Name":"Detail","Docs":"","Typewords":["string"]}]},
+	"Usage": {"Name":"Usage","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Month","Docs":"","Typewords":["string"]},{"Name":"Account","Docs":"","Typewords":["string"]},{"Name":"Domain","Docs":"","Typewords":["string"]},{"Name":"MessagesReceived","Docs":"","Typewords":["int64"]},{"Name":"BytesReceived","Docs":"","Typewords":["int64"]},{"Name":"MessagesSent","Docs":"","Typewords":["int64"]},{"Name":"BytesSent","Docs":"","Typewords":["int64"]},{"Name":"StoredBytes","Docs":"","Typewords":["int64"]},{"Name":"Updated","Docs":"","Typewords":["timestamp"]}]},
+	"Quarantined": {"Name":"Quarantined","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Received","Docs":"","Typewords":["timestamp"]},{"Name":"Expires","Docs":"","Typewords":["timestamp"]},{"Name":"Account","Docs":"","Typewords":["string"]},{"Name":"Mailbox","Docs":"","Typewords":["string"]},{"Name":"Reason","Docs":"","Typewords":["string"]},{"Name":"RemoteIP","Docs":"","Typewords":["string"]},{"Name":"MailFrom","Docs":"","Typewords":["string"]},{"Name":"RcptTo","Docs":"","Typewords":["string"]},{"Name":"MsgFrom","Docs":"","Typewords":["string"]},{"Name":"Subject","Docs":"","Typewords":["string"]},{"Name":"MessageID","Docs":"","Typewords":["string"]},{"Name":"Size","Docs":"","Typewords":["int64"]},{"Name":"Digested","Docs":"","Typewords":["timestamp"]}]},
+	"PasskeyCreationOptions": {"Name":"PasskeyCreationOptions","Docs":"","Fields":[{"Name":"Challenge","Docs":"","Typewords":["string"]},{"Name":"RPID","Docs":"","Typewords":["string"]},{"Name":"RPName","Docs":"","Typewords":["string"]},{"Name":"UserID","Docs":"","Typewords":["string"]},{"Name":"UserName","Docs":"","Typewords":["string"]},{"Name":"UserDisplayName","Docs":"","Typewords":["string"]},{"Name":"ExcludeCredentialIDs","Docs":"","Typewords":["[]","string"]},{"Name":"Algorithms","Docs":"","Typewords":["[]","int32"]},{"Name":"Timeout","Docs":"","Typewords":["int32"]}]},
+	"PasskeyAttestation": {"Name":"PasskeyAttestation","Docs":"","Fields":[{"Name":"ClientDataJSON","Docs":"","Typewords":["string"]},{"Name":"AttestationObject","Docs":"","Typewords":["string"]}]},
+	"APIToken": {"Name":"APIToken","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Created","Docs":"","Typewords":["timestamp"]},{"Name":"Name","Docs":"","Typewords":["string"]},{"Name":"Role","Docs":"","Typewords":["Role"]},{"Name":"LastUsed","Docs":"","Typewords":["timestamp"]}]},
+	"AuditEntry": {"Name":"AuditEntry","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Time","Docs":"","Typewords":["timestamp"]},{"Name":"Source","Docs":"","Typewords":["string"]},{"Name":"Actor","Docs":"","Typewords":["string"]},{"Name":"RemoteIP","Docs":"","Typewords":["string"]},{"Name":"Action","Docs":"","Typewords":["string"]},{"Name":"Params","Docs":"","Typewords":["string"]},{"Name":"Error","Docs":"","Typewords":["string"]}]},
 	"ClientConfigs": {"Name":"ClientConfigs","Docs":"","Fields":[{"Name":"Entries","Docs":"","Typewords":["[]","ClientConfigsEntry"]}]},
 	"ClientConfigsEntry": {"Name":"ClientConfigsEntry","Docs":"","Fields":[{"Name":"Protocol","Docs":"","Typewords":["string"]},{"Name":"Host","Docs":"","Typewords":["Domain"]},{"Name":"Port","Docs":"","Typewords":["int32"]},{"Name":"Listener","Docs":"","Typewords":["string"]},{"Name":"Note","Docs":"","Typewords":["string"]}]},
 	"HoldRule": {"Name":"HoldRule","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Account","Docs":"","Typewords":["string"]},{"Name":"SenderDomain","Docs":"","Typewords":["Domain"]},{"Name":"RecipientDomain","Docs":"","Typewords":["Domain"]},{"Name":"SenderDomainStr","Docs":"","Typewords":["string"]},{"Name":"RecipientDomainStr","Docs":"","Typewords":["string"]}]},
 	"Filter": {"Name":"Filter","Docs":"","Fields":[{"Name":"Max","Docs":"","Typewords":["int32"]},{"Name":"IDs","Docs":"","Typewords":["[]","int64"]},{"Name":"Account","Docs":"","Typewords":["string"]},{"Name":"From","Docs":"","Typewords":["string"]},{"Name":"To","Docs":"","Typewords":["string"]},{"Name":"Hold","Docs":"","Typewords":["nullable","bool"]},{"Name":"Submitted","Docs":"","Typewords":["string"]},{"Name":"NextAttempt","Docs":"","Typewords":["string"]},{"Name":"Transport","Docs":"","Typewords":["nullable","string"]}]},
 	"Sort": {"Name":"Sort","Docs":"","Fields":[{"Name":"Field","Docs":"","Typewords":["string"]},{"Name":"LastID","Docs":"","Typewords":["int64"]},{"Name":"Last","Docs":"","Typewords":["any"]},{"Name":"Asc","Docs":"","Typewords":["bool"]}]},
-	"Msg": {"Name":"Msg","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"BaseID","Docs":"","Typewords":["int64"]},{"Name":"Queued","Docs":"","Typewords":["timestamp"]},{"Name":"Hold","Docs":"","Typewords":["bool"]},{"Name":"SenderAccount","Docs":"","Typewords":["string"]},{"Name":"SenderLocalpart","Docs":"","Typewords":["Localpart"]},{"Name":"SenderDomain","Docs":"","Typewords":["IPDomain"]},{"Name":"SenderDomainStr","Docs":"","Typewords":["string"]},{"Name":"FromID","Docs":"","Typewords":["string"]},{"Name":"RecipientLocalpart","Docs":"","Typewords":["Localpart"]},{"Name":"RecipientDomain","Docs":"","Typewords":["IPDomain"]},{"Name":"RecipientDomainStr","Docs":"","Typewords":["string"]},{"Name":"Attempts","Docs":"","Typewords":["int32"]},{"Name":"MaxAttempts","Docs":"","Typewords":["int32"]},{"Name":"DialedIPs","Docs":"","Typewords":["{}","[]","IP"]},{"Name":"NextAttempt","Docs":"","Typewords":["timestamp"]},{"Name":"LastAttempt","Docs":"","Typewords":["nullable","timestamp"]},{"Name":"Results","Docs":"","Typewords":["[]","MsgResult"]},{"Name":"Has8bit","Docs":"","Typewords":["bool"]},{"Name":"SMTPUTF8","Docs":"","Typewords":["bool"]},{"Name":"IsDMARCReport","Docs":"","Typewords":["bool"]},{"Name":"IsTLSReport","Docs":"","Typewords":["bool"]},{"Name":"Size","Docs":"","Typewords":["int64"]},{"Name":"MessageID","Docs":"","Typewords":["string"]},{"Name":"MsgPrefix","Docs":"","Typewords":["nullable","string"]},{"Name":"Subject","Docs":"","Typewords":["string"]},{"Name":"DSNUTF8","Docs":"","Typewords":["nullable","string"]},{"Name":"Transport","Docs":"","Typewords":["string"]},{"Name":"RequireTLS","Docs":"","Typewords":["nullable","bool"]},{"Name":"FutureReleaseRequest","Docs":"","Typewords":["string"]},{"Name":"Extra","Docs":"","Typewords":["{}","string"]}]},
+	"Msg": {"Name":"Msg","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"BaseID","Docs":"","Typewords":["int64"]},{"Name":"Queued","Docs":"","Typewords":["timestamp"]},{"Name":"Hold","Docs":"","Typewords":["bool"]},{"Name":"HoldUntil","Docs":"","Typewords":["timestamp"]},{"Name":"SenderAccount","Docs":"","Typewords":["string"]},{"Name":"SenderLocalpart","Docs":"","Typewords":["Localpart"]},{"Name":"SenderDomain","Docs":"","Typewords":["IPDomain"]},{"Name":"SenderDomainStr","Docs":"","Typewords":["string"]},{"Name":"FromID","Docs":"","Typewords":["string"]},{"Name":"RecipientLocalpart","Docs":"","Typewords":["Localpart"]},{"Name":"RecipientDomain","Docs":"","Typewords":["IPDomain"]},{"Name":"RecipientDomainStr","Docs":"","Typewords":["string"]},{"Name":"Attempts","Docs":"","Typewords":["int32"]},{"Name":"MaxAttempts","Docs":"","Typewords":["int32"]},{"Name":"DialedIPs","Docs":"","Typewords":["{}","[]","IP"]},{"Name":"NextAttempt","Docs":"","Typewords":["timestamp"]},{"Name":"LastAttempt","Docs":"","Typewords":["nullable","timestamp"]},{"Name":"Results","Docs":"","Typewords":["[]","MsgResult"]},{"Name":"Has8bit","Docs":"","Typewords":["bool"]},{"Name":"SMTPUTF8","Docs":"","Typewords":["bool"]},{"Name":"IsDMARCReport","Docs":"","Typewords":["bool"]},{"Name":"IsTLSReport","Docs":"","Typewords":["bool"]},{"Name":"Size","Docs":"","Typewords":["int64"]},{"Name":"MessageID","Docs":"","Typewords":["string"]},{"Name":"MsgPrefix","Docs":"","Typewords":["nullable","string"]},{"Name":"Subject","Docs":"","Typewords":["string"]},{"Name":"DSNUTF8","Docs":"","Typewords":["nullable","string"]},{"Name":"Transport","Docs":"","Typewords":["string"]},{"Name":"RequireTLS","Docs":"","Typewords":["nullable","bool"]},{"Name":"FutureReleaseRequest","Docs":"","Typewords":["string"]},{"Name":"Extra","Docs":"","Typewords":["{}","string"]},{"Name":"TraceParent","Docs":"","Typewords":["string"]},{"Name":"XClientAddr","Docs":"","Typewords":["string"]},{"Name":"XClientHelo","Docs":"","Typewords":["string"]}]},
 	"IPDomain": {"Name":"IPDomain","Docs":"","Fields":[{"Name":"IP","Docs":"","Typewords":["IP"]},{"Name":"Domain","Docs":"","Typewords":["Domain"]}]},
 	"MsgResult": {"Name":"MsgResult","Docs":"","Fields":[{"Name":"Start","Docs":"","Typewords":["timestamp"]},{"Name":"Duration","Docs":"","Typewords":["int64"]},{"Name":"Success","Docs":"","Typewords":["bool"]},{"Name":"Code","Docs":"","Typewords":["int32"]},{"Name":"Secode","Docs":"","Typewords":["string"]},{"Name":"Error","Docs":"","Typewords":["string"]}]},
 	"RetiredFilter": {"Name":"RetiredFilter","Docs":"","Fields":[{"Name":"Max","Docs":"","Typewords":["int32"]},{"Name":"IDs","Docs":"","Typewords":["[]","int64"]},{"Name":"Account","Docs":"","Typewords":["string"]},{"Name":"From","Docs":"","Typewords":["string"]},{"Name":"To","Docs":"","Typewords":["string"]},{"Name":"Submitted","Docs":"","Typewords":["string"]},{"Name":"LastActivity","Docs":"","Typewords":["string"]},{"Name":"Transport","Docs":"","Typewords":["nullable","string"]},{"Name":"Success","Docs":"","Typewords":["nullable","bool"]}]},
@@ -1273,11 +1800,21 @@ export const types: TypenameMap = {
 	"HookRetiredFilter": {"Name":"HookRetiredFilter","Docs":"","Fields":[{"Name":"Max","Docs":"","Typewords":["int32"]},{"Name":"IDs","Docs":"","Typewords":["[]","int64"]},{"Name":"Account","Docs":"","Typewords":["string"]},{"Name":"Submitted","Docs":"","Typewords":["string"]},{"Name":"LastActivity","Docs":"","Typewords":["string"]},{"Name":"Event","Docs":"","Typewords":["string"]}]},
 	"HookRetiredSort": {"Name":"HookRetiredSort","Docs":"","Fields":[{"Name":"Field","Docs":"","Typewords":["string"]},{"Name":"LastID","Docs":"","Typewords":["int64"]},{"Name":"Last","Docs":"","Typewords":["any"]},{"Name":"Asc","Docs":"","Typewords":["bool"]}]},
 	"HookRetired": {"Name":"HookRetired","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"QueueMsgID","Docs":"","Typewords":["int64"]},{"Name":"FromID","Docs":"","Typewords":["string"]},{"Name":"MessageID","Docs":"","Typewords":["string"]},{"Name":"Subject","Docs":"","Typewords":["string"]},{"Name":"Extra","Docs":"","Typewords":["{}","string"]},{"Name":"Account","Docs":"","Typewords":["string"]},{"Name":"URL","Docs":"","Typewords":["string"]},{"Name":"Authorization","Docs":"","Typewords":["bool"]},{"Name":"IsIncoming","Docs":"","Typewords":["bool"]},{"Name":"OutgoingEvent","Docs":"","Typewords":["string"]},{"Name":"Payload","Docs":"","Typewords":["string"]},{"Name":"Submitted","Docs":"","Typewords":["timestamp"]},{"Name":"SupersededByID","Docs":"","Typewords":["int64"]},{"Name":"Attempts","Docs":"","Typewords":["int32"]},{"Name":"Results","Docs":"","Typewords":["[]","HookResult"]},{"Name":"Success","Docs":"","Typewords":["bool"]},{"Name":"LastActivity","Docs":"","Typewords":["timestamp"]},{"Name":"KeepUntil","Docs":"","Typewords":["timestamp"]}]},
+	"StaticReload": {"Name":"StaticReload","Docs":"","Fields":[{"Name":"Diff","Docs":"","Typewords":["string"]},{"Name":"Changed","Docs":"","Typewords":["[]","string"]},{"Name":"Restart","Docs":"","Typewords":["[]","string"]},{"Name":"Applied","Docs":"","Typewords":["bool"]}]},
+	"LogFilter": {"Name":"LogFilter","Docs":"","Fields":[{"Name":"Account","Docs":"","Typewords":["string"]},{"Name":"MessageID","Docs":"","Typewords":["string"]},{"Name":"Cid","Docs":"","Typewords":["string"]},{"Name":"Pkg","Docs":"","Typewords":["string"]},{"Name":"Level","Docs":"","Typewords":["string"]},{"Name":"Text","Docs":"","Typewords":["string"]},{"Name":"Max","Docs":"","Typewords":["int32"]}]},
+	"LogEntry": {"Name":"LogEntry","Docs":"","Fields":[{"Name":"Time","Docs":"","Typewords":["timestamp"]},{"Name":"Level","Docs":"","Typewords":["string"]},{"Name":"Pkg","Docs":"","Typewords":["string"]},{"Name":"Message","Docs":"","Typewords":["string"]},{"Name":"Fields","Docs":"","Typewords":["[]","LogField"]}]},
+	"LogField": {"Name":"LogField","Docs":"","Fields":[{"Name":"Key","Docs":"","Typewords":["string"]},{"Name":"Value","Docs":"","Typewords":["string"]}]},
 	"WebserverConfig": {"Name":"WebserverConfig","Docs":"","Fields":[{"Name":"WebDNSDomainRedirects","Docs":"","Typewords":["[]","[]","Domain"]},{"Name":"WebDomainRedirects","Docs":"","Typewords":["[]","[]","string"]},{"Name":"WebHandlers","Docs":"","Typewords":["[]","WebHandler"]}]},
-	"WebHandler": {"Name":"WebHandler","Docs":"","Fields":[{"Name":"LogName","Docs":"","Typewords":["string"]},{"Name":"Domain","Docs":"","Typewords":["string"]},{"Name":"PathRegexp","Docs":"","Typewords":["string"]},{"Name":"DontRedirectPlainHTTP","Docs":"","Typewords":["bool"]},{"Name":"Compress","Docs":"","Typewords":["bool"]},{"Name":"WebStatic","Docs":"","Typewords":["nullable","WebStatic"]},{"Name":"WebRedirect","Docs":"","Typewords":["nullable","WebRedirect"]},{"Name":"WebForward","Docs":"","Typewords":["nullable","WebForward"]},{"Name":"Name","Docs":"","Typewords":["string"]},{"Name":"DNSDomain","Docs":"","Typewords":["Domain"]}]},
+	"WebHandler": {"Name":"WebHandler","Docs":"","Fields":[{"Name":"LogName","Docs":"","Typewords":["string"]},{"Name":"Domain","Docs":"","Typewords":["string"]},{"Name":"PathRegexp","Docs":"","Typewords":["string"]},{"Name":"DontRedirectPlainHTTP","Docs":"","Typewords":["bool"]},{"Name":"Compress","Docs":"","Typewords":["bool"]},{"Name":"Access","Docs":"","Typewords":["nullable","WebAccess"]},{"Name":"RateLimit","Docs":"","Typewords":["nullable","WebRateLimit"]},{"Name":"Rules","Docs":"","Typewords":["[]","WebRule"]},{"Name":"WebStatic","Docs":"","Typewords":["nullable","WebStatic"]},{"Name":"WebRedirect","Docs":"","Typewords":["nullable","WebRedirect"]},{"Name":"WebForward","Docs":"","Typewords":["nullable","WebForward"]},{"Name":"Name","Docs":"","Typewords":["string"]},{"Name":"DNSDomain","Docs":"","Typewords":["Domain"]}]},
+	"WebAccess": {"Name":"WebAccess","Docs":"","Fields":[{"Name":"IPAllow","Docs":"","Typewords":["[]","string"]},{"Name":"IPDeny","Docs":"","Typewords":["[]","string"]},{"Name":"BasicAuth","Docs":"","Typewords":["nullable","WebBasicAuth"]},{"Name":"OIDC","Docs":"","Typewords":["nullable","WebOIDCAuth"]}]},
+	"WebBasicAuth": {"Name":"WebBasicAuth","Docs":"","Fields":[{"Name":"Realm","Docs":"","Typewords":["string"]},{"Name":"AllAccounts","Docs":"","Typewords":["bool"]},{"Name":"Accounts","Docs":"","Typewords":["[]","string"]},{"Name":"HTPasswdFile","Docs":"","Typewords":["string"]}]},
+	"WebOIDCAuth": {"Name":"WebOIDCAuth","Docs":"","Fields":[{"Name":"Emails","Docs":"","Typewords":["[]","string"]},{"Name":"Domains","Docs":"","Typewords":["[]","string"]}]},
+	"WebRateLimit": {"Name":"WebRateLimit","Docs":"","Fields":[{"Name":"Requests","Docs":"","Typewords":["int64"]},{"Name":"Window","Docs":"","Typewords":["int64"]}]},
+	"WebRule": {"Name":"WebRule","Docs":"","Fields":[{"Name":"PathRegexp","Docs":"","Typewords":["string"]},{"Name":"Methods","Docs":"","Typewords":["[]","string"]},{"Name":"UserAgentRegexp","Docs":"","Typewords":["string"]},{"Name":"MaxBodySize","Docs":"","Typewords":["int64"]}]},
 	"WebStatic": {"Name":"WebStatic","Docs":"","Fields":[{"Name":"StripPrefix","Docs":"","Typewords":["string"]},{"Name":"Root","Docs":"","Typewords":["string"]},{"Name":"ListFiles","Docs":"","Typewords":["bool"]},{"Name":"ContinueNotFound","Docs":"","Typewords":["bool"]},{"Name":"ResponseHeaders","Docs":"","Typewords":["{}","string"]}]},
 	"WebRedirect": {"Name":"WebRedirect","Docs":"","Fields":[{"Name":"BaseURL","Docs":"","Typewords":["string"]},{"Name":"OrigPathRegexp","Docs":"","Typewords":["string"]},{"Name":"ReplacePath","Docs":"","Typewords":["string"]},{"Name":"StatusCode","Docs":"","Typewords":["int32"]}]},
-	"WebForward": {"Name":"WebForward","Docs":"","Fields":[{"Name":"StripPath","Docs":"","Typewords":["bool"]},{"Name":"URL","Docs":"","Typewords":["string"]},{"Name":"ResponseHeaders","Docs":"","Typewords":["{}","string"]}]},
+	"WebForward": {"Name":"WebForward","Docs":"","Fields":[{"Name":"StripPath","Docs":"","Typewords":["bool"]},{"Name":"URL","Docs":"","Typewords":["string"]},{"Name":"ResponseHeaders","Docs":"","Typewords":["{}","string"]},{"Name":"HTTP2","Docs":"","Typewords":["bool"]},{"Name":"DialTimeout","Docs":"","Typewords":["int64"]},{"Name":"ResponseTimeout","Docs":"","Typewords":["int64"]},{"Name":"RequestHeaders","Docs":"","Typewords":["{}","string"]},{"Name":"RequestHeadersRemove","Docs":"","Typewords":["[]","string"]},{"Name":"RequestHeaderRewrites","Docs":"","Typewords":["[]","WebHeaderRewrite"]},{"Name":"ResponseHeadersRemove","Docs":"","Typewords":["[]","string"]},{"Name":"ResponseHeaderRewrites","Docs":"","Typewords":["[]","WebHeaderRewrite"]}]},
+	"WebHeaderRewrite": {"Name":"WebHeaderRewrite","Docs":"","Fields":[{"Name":"Header","Docs":"","Typewords":["string"]},{"Name":"ValueRegexp","Docs":"","Typewords":["string"]},{"Name":"Replace","Docs":"","Typewords":["string"]}]},
 	"Transport": {"Name":"Transport","Docs":"","Fields":[{"Name":"Submissions","Docs":"","Typewords":["nullable","TransportSMTP"]},{"Name":"Submission","Docs":"","Typewords":["nullable","TransportSMTP"]},{"Name":"SMTP","Docs":"","Typewords":["nullable","TransportSMTP"]},{"Name":"Socks","Docs":"","Typewords":["nullable","TransportSocks"]},{"Name":"Direct","Docs":"","Typewords":["nullable","TransportDirect"]}]},
 	"TransportSMTP": {"Name":"TransportSMTP","Docs":"","Fields":[{"Name":"Host","Docs":"","Typewords":["string"]},{"Name":"Port","Docs":"","Typewords":["int32"]},{"Name":"STARTTLSInsecureSkipVerify","Docs":"","Typewords":["bool"]},{"Name":"NoSTARTTLS","Docs":"","Typewords":["bool"]},{"Name":"Auth","Docs":"","Typewords":["nullable","SMTPAuth"]}]},
 	"SMTPAuth": {"Name":"SMTPAuth","Docs":"","Fields":[{"Name":"Username","Docs":"","Typewords":["string"]},{"Name":"Password","Docs":"","Typewords":["string"]},{"Name":"Mechanisms","Docs":"","Typewords":["[]","string"]}]},
@@ -1288,7 +1825,13 @@ export const types: TypenameMap = {
 	"SuppressAddress": {"Name":"SuppressAddress","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Inserted","Docs":"","Typewords":["timestamp"]},{"Name":"ReportingAddress","Docs":"","Typewords":["string"]},{"Name":"Until","Docs":"","Typewords":["timestamp"]},{"Name":"Comment","Docs":"","Typewords":["string"]}]},
 	"TLSResult": {"Name":"TLSResult","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"PolicyDomain","Docs":"","Typewords":["string"]},{"Name":"DayUTC","Docs":"","Typewords":["string"]},{"Name":"RecipientDomain","Docs":"","Typewords":["string"]},{"Name":"Created","Docs":"","Typewords":["timestamp"]},{"Name":"Updated","Docs":"","Typewords":["timestamp"]},{"Name":"IsHost","Docs":"","Typewords":["bool"]},{"Name":"SendReport","Docs":"","Typewords":["bool"]},{"Name":"SentToRecipientDomain","Docs":"","Typewords":["bool"]},{"Name":"RecipientDomainReportingAddresses","Docs":"","Typewords":["[]","string"]},{"Name":"SentToPolicyDomain","Docs":"","Typewords":["bool"]},{"Name":"Results","Docs":"","Typewords":["[]","Result"]}]},
 	"TLSRPTSuppressAddress": {"Name":"TLSRPTSuppressAddress","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Inserted","Docs":"","Typewords":["timestamp"]},{"Name":"ReportingAddress","Docs":"","Typewords":["string"]},{"Name":"Until","Docs":"","Typewords":["timestamp"]},{"Name":"Comment","Docs":"","Typewords":["string"]}]},
-	"Dynamic": {"Name":"Dynamic","Docs":"","Fields":[{"Name":"Domains","Docs":"","Typewords":["{}","ConfigDomain"]},{"Name":"Accounts","Docs":"","Typewords":["{}","Account"]},{"Name":"WebDomainRedirects","Docs":"","Typewords":["{}","string"]},{"Name":"WebHandlers","Docs":"","Typewords":["[]","WebHandler"]},{"Name":"Routes","Docs":"","Typewords":["[]","Route"]},{"Name":"MonitorDNSBLs","Docs":"","Typewords":["[]","string"]},{"Name":"MonitorDNSBLZones","Docs":"","Typewords":["[]","Domain"]}]},
+	"Dynamic": {"Name":"Dynamic","Docs":"","Fields":[{"Name":"Domains","Docs":"","Typewords":["{}","ConfigDomain"]},{"Name":"Accounts","Docs":"","Typewords":["{}","Account"]},{"Name":"WebDomainRedirects","Docs":"","Typewords":["{}","string"]},{"Name":"WebHandlers","Docs":"","Typewords":["[]","WebHandler"]},{"Name":"Routes","Docs":"","Typewords":["[]","Route"]},{"Name":"AddressRewrites","Docs":"","Typewords":["[]","AddressRewrite"]},{"Name":"MonitorDNSBLs","Docs":"","Typewords":["[]","string"]},{"Name":"Version","Docs":"","Typewords":["int32"]},{"Name":"MonitorDNSBLZones","Docs":"","Typewords":["[]","Domain"]}]},
+	"Status": {"Name":"Status","Docs":"","Fields":[{"Name":"Domain","Docs":"","Typewords":["string"]},{"Name":"PolicyID","Docs":"","Typewords":["string"]},{"Name":"Mode","Docs":"","Typewords":["Mode"]},{"Name":"EnforceAfter","Docs":"","Typewords":["int64"]},{"Name":"TestingStart","Docs":"","Typewords":["timestamp"]},{"Name":"LastFailure","Docs":"","Typewords":["timestamp"]},{"Name":"CleanReports","Docs":"","Typewords":["int32"]},{"Name":"PromoteAt","Docs":"","Typewords":["timestamp"]}]},
+	"AdminScope": {"Name":"AdminScope","Docs":"","Fields":[{"Name":"LoginAddress","Docs":"","Typewords":["string"]},{"Name":"Domains","Docs":"","Typewords":["[]","Domain"]}]},
+	"DomainSetup": {"Name":"DomainSetup","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Created","Docs":"","Typewords":["timestamp"]},{"Name":"Domain","Docs":"","Typewords":["string"]},{"Name":"Sign","Docs":"","Typewords":["[]","string"]},{"Name":"MTASTSEnforce","Docs":"","Typewords":["bool"]},{"Name":"LastCheck","Docs":"","Typewords":["timestamp"]}]},
+	"DomainSetupStatus": {"Name":"DomainSetupStatus","Docs":"","Fields":[{"Name":"Setup","Docs":"","Typewords":["DomainSetup"]},{"Name":"Records","Docs":"","Typewords":["[]","DomainSetupRecord"]},{"Name":"Checks","Docs":"","Typewords":["[]","DomainSetupCheck"]},{"Name":"Ready","Docs":"","Typewords":["bool"]}]},
+	"DomainSetupRecord": {"Name":"DomainSetupRecord","Docs":"","Fields":[{"Name":"Name","Docs":"","Typewords":["string"]},{"Name":"Type","Docs":"","Typewords":["string"]},{"Name":"Value","Docs":"","Typewords":["string"]},{"Name":"Zone","Docs":"","Typewords":["string"]},{"Name":"Check","Docs":"","Typewords":["string"]}]},
+	"DomainSetupCheck": {"Name":"DomainSetupCheck","Docs":"","Fields":[{"Name":"Name","Docs":"","Typewords":["string"]},{"Name":"Errors","Docs":"","Typewords":["[]","string"]}]},
 	"CSRFToken": {"Name":"CSRFToken","Docs":"","Values":null},
 	"DMARCPolicy": {"Name":"DMARCPolicy","Docs":"","Values":[{"Name":"PolicyEmpty","Value":"","Docs":""},{"Name":"PolicyNone","Value":"none","Docs":""},{"Name":"PolicyQuarantine","Value":"quarantine","Docs":""},{"Name":"PolicyReject","Value":"reject","Docs":""}]},
 	"Align": {"Name":"Align","Docs":"","Values":[{"Name":"AlignStrict","Value":"s","Docs":""},{"Name":"AlignRelaxed","Value":"r","Docs":""}]},
@@ -1304,10 +1847,14 @@ export const types: TypenameMap = {
 	"DKIMResult": {"Name":"DKIMResult","Docs":"","Values":[{"Name":"DKIMAbsent","Value":"","Docs":""},{"Name":"DKIMNone","Value":"none","Docs":""},{"Name":"DKIMPass","Value":"pass","Docs":""},{"Name":"DKIMFail","Value":"fail","Docs":""},{"Name":"DKIMPolicy","Value":"policy","Docs":""},{"Name":"DKIMNeutral","Value":"neutral","Docs":""},{"Name":"DKIMTemperror","Value":"temperror","Docs":""},{"Name":"DKIMPermerror","Value":"permerror","Docs":""}]},
 	"SPFDomainScope": {"Name":"SPFDomainScope","Docs":"","Values":[{"Name":"SPFDomainScopeAbsent","Value":"","Docs":""},{"Name":"SPFDomainScopeHelo","Value":"helo","Docs":""},{"Name":"SPFDomainScopeMailFrom","Value":"mfrom","Docs":""}]},
 	"SPFResult": {"Name":"SPFResult","Docs":"","Values":[{"Name":"SPFAbsent","Value":"","Docs":""},{"Name":"SPFNone","Value":"none","Docs":""},{"Name":"SPFNeutral","Value":"neutral","Docs":""},{"Name":"SPFPass","Value":"pass","Docs":""},{"Name":"SPFFail","Value":"fail","Docs":""},{"Name":"SPFSoftfail","Value":"softfail","Docs":""},{"Name":"SPFTemperror","Value":"temperror","Docs":""},{"Name":"SPFPermerror","Value":"permerror","Docs":""}]},
+	"EventKind": {"Name":"EventKind","Docs":"","Values":[{"Name":"EventReceived","Value":"received","Docs":""},{"Name":"EventRuleset","Value":"ruleset","Docs":""},{"Name":"EventJunk","Value":"junk","Docs":""},{"Name":"EventDelivered","Value":"delivered","Docs":""},{"Name":"EventRejected","Value":"rejected","Docs":""},{"Name":"EventQuarantined","Value":"quarantined","Docs":""},{"Name":"EventDiscarded","Value":"discarded","Docs":""},{"Name":"EventQueued","Value":"queued","Docs":""},{"Name":"EventAttempt","Value":"attempt","Docs":""},{"Name":"EventFailed","Value":"failed","Docs":""}]},
+	"Role": {"Name":"Role","Docs":"","Values":[{"Name":"RoleReadonly","Value":"readonly","Docs":""},{"Name":"RoleQueue","Value":"queue","Docs":""},{"Name":"RoleDomains","Value":"domains","Docs":""},{"Name":"RoleAdmin","Value":"admin","Docs":""}]},
 	"IP": {"Name":"IP","Docs":"","Values":[]},
 }
 
 export const parser = {
+	PasskeyRequestOptions: (v: any) => parse("PasskeyRequestOptions", v) as PasskeyRequestOptions,
+	PasskeyAssertion: (v: any) => parse("PasskeyAssertion", v) as PasskeyAssertion,
 	CheckResult: (v: any) => parse("CheckResult", v) as CheckResult,
 	DNSSECResult: (v: any) => parse("DNSSECResult", v) as DNSSECResult,
 	IPRevCheckResult: (v: any) => parse("IPRevCheckResult", v) as IPRevCheckResult,
@@ -1352,14 +1899,21 @@ export const parser = {
 	Address: (v: any) => parse("Address", v) as Address,
 	Destination: (v: any) => parse("Destination", v) as Destination,
 	Ruleset: (v: any) => parse("Ruleset", v) as Ruleset,
+	DomainAuth: (v: any) => parse("DomainAuth", v) as DomainAuth,
+	LDAPAuth: (v: any) => parse("LDAPAuth", v) as LDAPAuth,
+	PAMAuth: (v: any) => parse("PAMAuth", v) as PAMAuth,
+	Footer: (v: any) => parse("Footer", v) as Footer,
+	AddressRewrite: (v: any) => parse("AddressRewrite", v) as AddressRewrite,
 	Account: (v: any) => parse("Account", v) as Account,
 	OutgoingWebhook: (v: any) => parse("OutgoingWebhook", v) as OutgoingWebhook,
 	IncomingWebhook: (v: any) => parse("IncomingWebhook", v) as IncomingWebhook,
 	SubjectPass: (v: any) => parse("SubjectPass", v) as SubjectPass,
 	AutomaticJunkFlags: (v: any) => parse("AutomaticJunkFlags", v) as AutomaticJunkFlags,
 	JunkFilter: (v: any) => parse("JunkFilter", v) as JunkFilter,
+	PasswordPolicy: (v: any) => parse("PasswordPolicy", v) as PasswordPolicy,
 	AddressAlias: (v: any) => parse("AddressAlias", v) as AddressAlias,
 	PolicyRecord: (v: any) => parse("PolicyRecord", v) as PolicyRecord,
+	CertificateInfo: (v: any) => parse("CertificateInfo", v) as CertificateInfo,
 	TLSReportRecord: (v: any) => parse("TLSReportRecord", v) as TLSReportRecord,
 	Report: (v: any) => parse("Report", v) as Report,
 	TLSRPTDateRange: (v: any) => parse("TLSRPTDateRange", v) as TLSRPTDateRange,
@@ -1382,6 +1936,21 @@ export const parser = {
 	SPFAuthResult: (v: any) => parse("SPFAuthResult", v) as SPFAuthResult,
 	DMARCSummary: (v: any) => parse("DMARCSummary", v) as DMARCSummary,
 	Reverse: (v: any) => parse("Reverse", v) as Reverse,
+	ProtocolSession: (v: any) => parse("ProtocolSession", v) as ProtocolSession,
+	Passkey: (v: any) => parse("Passkey", v) as Passkey,
+	AccountDeletion: (v: any) => parse("AccountDeletion", v) as AccountDeletion,
+	SubmissionIncident: (v: any) => parse("SubmissionIncident", v) as SubmissionIncident,
+	SpamtrapHit: (v: any) => parse("SpamtrapHit", v) as SpamtrapHit,
+	AbuseReport: (v: any) => parse("AbuseReport", v) as AbuseReport,
+	LineEndingSource: (v: any) => parse("LineEndingSource", v) as LineEndingSource,
+	AutoBanEntry: (v: any) => parse("AutoBanEntry", v) as AutoBanEntry,
+	MessageEvent: (v: any) => parse("MessageEvent", v) as MessageEvent,
+	Usage: (v: any) => parse("Usage", v) as Usage,
+	Quarantined: (v: any) => parse("Quarantined", v) as Quarantined,
+	PasskeyCreationOptions: (v: any) => parse("PasskeyCreationOptions", v) as PasskeyCreationOptions,
+	PasskeyAttestation: (v: any) => parse("PasskeyAttestation", v) as PasskeyAttestation,
+	APIToken: (v: any) => parse("APIToken", v) as APIToken,
+	AuditEntry: (v: any) => parse("AuditEntry", v) as AuditEntry,
 	ClientConfigs: (v: any) => parse("ClientConfigs", v) as ClientConfigs,
 	ClientConfigsEntry: (v: any) => parse("ClientConfigsEntry", v) as ClientConfigsEntry,
 	HoldRule: (v: any) => parse("HoldRule", v) as HoldRule,
@@ -1400,11 +1969,21 @@ export const parser = {
 	HookRetiredFilter: (v: any) => parse("HookRetiredFilter", v) as HookRetiredFilter,
 	HookRetiredSort: (v: any) => parse("HookRetiredSort", v) as HookRetiredSort,
 	HookRetired: (v: any) => parse("HookRetired", v) as HookRetired,
+	StaticReload: (v: any) => parse("StaticReload", v) as StaticReload,
+	LogFilter: (v: any) => parse("LogFilter", v) as LogFilter,
+	LogEntry: (v: any) => parse("LogEntry", v) as LogEntry,
+	LogField: (v: any) => parse("LogField", v) as LogField,
 	WebserverConfig: (v: any) => parse("WebserverConfig", v) as WebserverConfig,
 	WebHandler: (v: any) => parse("WebHandler", v) as WebHandler,
+	WebAccess: (v: any) => parse("WebAccess", v) as WebAccess,
+	WebBasicAuth: (v: any) => parse("WebBasicAuth", v) as WebBasicAuth,
+	WebOIDCAuth: (v: any) => parse("WebOIDCAuth", v) as WebOIDCAuth,
+	WebRateLimit: (v: any) => parse("WebRateLimit", v) as WebRateLimit,
+	WebRule: (v: any) => parse("WebRule", v) as WebRule,
 	WebStatic: (v: any) => parse("WebStatic", v) as WebStatic,
 	WebRedirect: (v: any) => parse("WebRedirect", v) as WebRedirect,
 	WebForward: (v: any) => parse("WebForward", v) as WebForward,
+	WebHeaderRewrite: (v: any) => parse("WebHeaderRewrite", v) as WebHeaderRewrite,
 	Transport: (v: any) => parse("Transport", v) as Transport,
 	TransportSMTP: (v: any) => parse("TransportSMTP", v) as TransportSMTP,
 	SMTPAuth: (v: any) => parse("SMTPAuth", v) as SMTPAuth,
@@ -1416,6 +1995,12 @@ export const parser = {
 	TLSResult: (v: any) => parse("TLSResult", v) as TLSResult,
 	TLSRPTSuppressAddress: (v: any) => parse("TLSRPTSuppressAddress", v) as TLSRPTSuppressAddress,
 	Dynamic: (v: any) => parse("Dynamic", v) as Dynamic,
+	Status: (v: any) => parse("Status", v) as Status,
+	AdminScope: (v: any) => parse("AdminScope", v) as AdminScope,
+	DomainSetup: (v: any) => parse("DomainSetup", v) as DomainSetup,
+	DomainSetupStatus: (v: any) => parse("DomainSetupStatus", v) as DomainSetupStatus,
+	DomainSetupRecord: (v: any) => parse("DomainSetupRecord", v) as DomainSetupRecord,
+	DomainSetupCheck: (v: any) => parse("DomainSetupCheck", v) as DomainSetupCheck,
 	CSRFToken: (v: any) => parse("CSRFToken", v) as CSRFToken,
 	DMARCPolicy: (v: any) => parse("DMARCPolicy", v) as DMARCPolicy,
 	Align: (v: any) => parse("Align", v) as Align,
@@ -1431,6 +2016,8 @@ export const parser = {
 	DKIMResult: (v: any) => parse("DKIMResult", v) as DKIMResult,
 	SPFDomainScope: (v: any) => parse("SPFDomainScope", v) as SPFDomainScope,
 	SPFResult: (v: any) => parse("SPFResult", v) as SPFResult,
+	EventKind: (v: any) => parse("EventKind", v) as EventKind,
+	Role: (v: any) => parse("Role", v) as Role,
 	IP: (v: any) => parse("IP", v) as IP,
 }
 
@@ -1475,12 +2062,70 @@ export class Client {
 	}
 
 	// Login returns a session token for the credentials, or fails with error code
-	// "user:badLogin". Call LoginPrep to get a loginToken.
-	async Login(loginToken: string, password: string): Promise<CSRFToken> {
+	// "user:badLogin". Call LoginPrep to get a loginToken. If two-factor
+	// authentication is enabled and totpCode is empty, the error code is
+	// "user:totpRequired", and the login must be retried with a code.
+	// 
+	// The server admin logs in with an empty username and the admin password. Domain
+	// admins log in with the login address and password of their account.
+	async Login(loginToken: string, username: string, password: string, totpCode: string): Promise<CSRFToken> {
 		const fn: string = "Login"
-		const paramTypes: string[][] = [["string"],["string"]]
+		const paramTypes: string[][] = [["string"],["string"],["string"],["string"]]
+		const returnTypes: string[][] = [["CSRFToken"]]
+		const params: any[] = [loginToken, username, password, totpCode]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as CSRFToken
+	}
+
+	// PasskeyLoginPrep returns options for logging in with a passkey with
+	// navigator.credentials.get in the browser.
+	async PasskeyLoginPrep(): Promise<PasskeyRequestOptions> {
+		const fn: string = "PasskeyLoginPrep"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["PasskeyRequestOptions"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as PasskeyRequestOptions
+	}
+
+	// PasskeyLogin returns a session token for a login with a passkey, started with
+	// PasskeyLoginPrep, or fails with error code "user:loginFailed". Call LoginPrep
+	// to get a loginToken after the user selected a passkey.
+	async PasskeyLogin(loginToken: string, response: PasskeyAssertion): Promise<CSRFToken> {
+		const fn: string = "PasskeyLogin"
+		const paramTypes: string[][] = [["string"],["PasskeyAssertion"]]
+		const returnTypes: string[][] = [["CSRFToken"]]
+		const params: any[] = [loginToken, response]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as CSRFToken
+	}
+
+	// OIDCEnabled returns whether single sign-on through an OpenID Connect identity
+	// provider is configured.
+	async OIDCEnabled(): Promise<boolean> {
+		const fn: string = "OIDCEnabled"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["bool"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as boolean
+	}
+
+	// OIDCLoginPrep starts a single sign-on login, returning the URL of the identity
+	// provider to navigate to. The identity provider sends the browser back to this
+	// page with "code" and "state" query string parameters, for OIDCLogin.
+	async OIDCLoginPrep(): Promise<string> {
+		const fn: string = "OIDCLoginPrep"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["string"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as string
+	}
+
+	// OIDCLogin returns a session token for a single sign-on login started with
+	// OIDCLoginPrep, or fails with error code "user:loginFailed". Call LoginPrep to
+	// get a loginToken.
+	async OIDCLogin(loginToken: string, code: string, state: string): Promise<CSRFToken> {
+		const fn: string = "OIDCLogin"
+		const paramTypes: string[][] = [["string"],["string"],["string"]]
 		const returnTypes: string[][] = [["CSRFToken"]]
-		const params: any[] = [loginToken, password]
+		const params: any[] = [loginToken, code, state]
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as CSRFToken
 	}
 
@@ -1503,7 +2148,8 @@ export class Client {
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as CheckResult
 	}
 
-	// Domains returns all configured domain names, in UTF-8 for IDNA domains.
+	// Domains returns all configured domain names, in UTF-8 for IDNA domains. For
+	// domain admins, only their domains are returned.
 	async Domains(): Promise<Domain[] | null> {
 		const fn: string = "Domains"
 		const paramTypes: string[][] = []
@@ -1548,7 +2194,8 @@ export class Client {
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as [{ [key: string]: string }, { [key: string]: Alias }]
 	}
 
-	// Accounts returns the names of all configured accounts.
+	// Accounts returns the names of all configured accounts. For domain admins, only
+	// accounts with a default domain they manage are returned.
 	async Accounts(): Promise<string[] | null> {
 		const fn: string = "Accounts"
 		const paramTypes: string[][] = []
@@ -1584,6 +2231,16 @@ export class Client {
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as PolicyRecord[] | null
 	}
 
+	// ACMECertificates returns the certificates obtained through ACME, for all ACME
+	// providers, with the provider and certificate authority that issued them.
+	async ACMECertificates(): Promise<CertificateInfo[] | null> {
+		const fn: string = "ACMECertificates"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["[]","CertificateInfo"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as CertificateInfo[] | null
+	}
+
 	// TLSReports returns TLS reports overlapping with period start/end, for the given
 	// policy domain (or all domains if empty). The reports are sorted first by period
 	// end (most recent first), then by policy domain.
@@ -1745,7 +2402,7 @@ export class Client {
 
 	// SetPassword saves a new password for an account, invalidating the previous password.
 	// Sessions are not interrupted, and will keep working. New login attempts must use the new password.
-	// Password must be at least 8 characters.
+	// Password must be at least 8 characters, and meet the password policy.
 	async SetPassword(accountName: string, password: string): Promise<void> {
 		const fn: string = "SetPassword"
 		const paramTypes: string[][] = [["string"],["string"]]
@@ -1754,12 +2411,353 @@ export class Client {
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
 	}
 
+	// AccountPasswordChangeRequired returns whether the user of the account must
+	// change the password.
+	async AccountPasswordChangeRequired(accountName: string): Promise<boolean> {
+		const fn: string = "AccountPasswordChangeRequired"
+		const paramTypes: string[][] = [["string"]]
+		const returnTypes: string[][] = [["bool"]]
+		const params: any[] = [accountName]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as boolean
+	}
+
+	// AccountPasswordChangeRequire sets whether the user of the account must change
+	// the password, e.g. after it may have been compromised. Until then, the
+	// password is only accepted for the account web interface, and its web sessions
+	// are ended. App passwords keep working.
+	async AccountPasswordChangeRequire(accountName: string, required: boolean): Promise<void> {
+		const fn: string = "AccountPasswordChangeRequire"
+		const paramTypes: string[][] = [["string"],["bool"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [accountName, required]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// AccountProtocolSessions returns the active and recent IMAP and SMTP submission
+	// sessions of an account.
+	async AccountProtocolSessions(accountName: string): Promise<ProtocolSession[] | null> {
+		const fn: string = "AccountProtocolSessions"
+		const paramTypes: string[][] = [["string"]]
+		const returnTypes: string[][] = [["[]","ProtocolSession"]]
+		const params: any[] = [accountName]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as ProtocolSession[] | null
+	}
+
+	// AccountProtocolSessionClose closes an active IMAP or SMTP submission session
+	// of an account. If id is 0, all active sessions of the account are closed.
+	async AccountProtocolSessionClose(accountName: string, id: number): Promise<number> {
+		const fn: string = "AccountProtocolSessionClose"
+		const paramTypes: string[][] = [["string"],["int64"]]
+		const returnTypes: string[][] = [["int32"]]
+		const params: any[] = [accountName, id]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as number
+	}
+
+	// AccountTOTPReset disables two-factor authentication for an account, e.g. when
+	// the user lost access to the authenticator app and recovery codes. The user can
+	// then log in with just the password, and set up two-factor authentication again.
+	async AccountTOTPReset(accountName: string): Promise<void> {
+		const fn: string = "AccountTOTPReset"
+		const paramTypes: string[][] = [["string"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [accountName]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// AccountPasskeys returns the passkeys of an account.
+	async AccountPasskeys(accountName: string): Promise<Passkey[] | null> {
+		const fn: string = "AccountPasskeys"
+		const paramTypes: string[][] = [["string"]]
+		const returnTypes: string[][] = [["[]","Passkey"]]
+		const params: any[] = [accountName]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as Passkey[] | null
+	}
+
+	// AccountPasskeysReset removes all passkeys of an account, e.g. when the user
+	// lost access to devices.
+	async AccountPasskeysReset(accountName: string): Promise<number> {
+		const fn: string = "AccountPasskeysReset"
+		const paramTypes: string[][] = [["string"]]
+		const returnTypes: string[][] = [["int32"]]
+		const params: any[] = [accountName]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as number
+	}
+
+	// AccountDeletions returns the accounts with a pending deletion.
+	async AccountDeletions(): Promise<AccountDeletion[] | null> {
+		const fn: string = "AccountDeletions"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["[]","AccountDeletion"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as AccountDeletion[] | null
+	}
+
+	// AccountDeletionRequest requests deletion of an account, disabling logins
+	// immediately, and purging the account and all its data after the grace period.
+	async AccountDeletionRequest(accountName: string, grace: number): Promise<AccountDeletion> {
+		const fn: string = "AccountDeletionRequest"
+		const paramTypes: string[][] = [["string"],["int64"]]
+		const returnTypes: string[][] = [["AccountDeletion"]]
+		const params: any[] = [accountName, grace]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as AccountDeletion
+	}
+
+	// AccountDeletionCancel cancels a pending deletion of an account, enabling logins
+	// again.
+	async AccountDeletionCancel(accountName: string): Promise<void> {
+		const fn: string = "AccountDeletionCancel"
+		const paramTypes: string[][] = [["string"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [accountName]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// AccountDeletionPurge purges an account with a pending deletion now, without
+	// waiting for the grace period to end.
+	async AccountDeletionPurge(accountName: string): Promise<void> {
+		const fn: string = "AccountDeletionPurge"
+		const paramTypes: string[][] = [["string"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [accountName]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// SubmissionIncidents returns the most recent anomalies detected in submissions
+	// by an account, most recent first.
+	async SubmissionIncidents(accountName: string, max: number): Promise<SubmissionIncident[] | null> {
+		const fn: string = "SubmissionIncidents"
+		const paramTypes: string[][] = [["string"],["int32"]]
+		const returnTypes: string[][] = [["[]","SubmissionIncident"]]
+		const params: any[] = [accountName, max]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as SubmissionIncident[] | null
+	}
+
+	// SubmissionThrottleClear ends throttling of submissions for an account before
+	// the throttle duration has passed. It returns the number of throttles cleared.
+	async SubmissionThrottleClear(accountName: string): Promise<number> {
+		const fn: string = "SubmissionThrottleClear"
+		const paramTypes: string[][] = [["string"]]
+		const returnTypes: string[][] = [["int32"]]
+		const params: any[] = [accountName]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as number
+	}
+
+	// SpamtrapHits returns the most recent messages sent to spamtrap addresses, most
+	// recent first, at most max if max > 0. Domain admins only see hits for spamtraps
+	// in their domains.
+	async SpamtrapHits(max: number): Promise<SpamtrapHit[] | null> {
+		const fn: string = "SpamtrapHits"
+		const paramTypes: string[][] = [["int32"]]
+		const returnTypes: string[][] = [["[]","SpamtrapHit"]]
+		const params: any[] = [max]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as SpamtrapHit[] | null
+	}
+
+	// AbuseReports returns the most recent abuse reports received at role addresses,
+	// most recent first, at most max if max > 0.
+	async AbuseReports(max: number): Promise<AbuseReport[] | null> {
+		const fn: string = "AbuseReports"
+		const paramTypes: string[][] = [["int32"]]
+		const returnTypes: string[][] = [["[]","AbuseReport"]]
+		const params: any[] = [max]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as AbuseReport[] | null
+	}
+
+	// AbuseReportSetHandled marks an abuse report as handled, or as needing attention
+	// again.
+	async AbuseReportSetHandled(reportID: number, handled: boolean): Promise<void> {
+		const fn: string = "AbuseReportSetHandled"
+		const paramTypes: string[][] = [["int64"],["bool"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [reportID, handled]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// LineEndingSources returns the remote IPs that sent messages with line ending
+	// anomalies in the SMTP message data, most recent first, at most max if max > 0.
+	async LineEndingSources(max: number): Promise<LineEndingSource[] | null> {
+		const fn: string = "LineEndingSources"
+		const paramTypes: string[][] = [["int32"]]
+		const returnTypes: string[][] = [["[]","LineEndingSource"]]
+		const params: any[] = [max]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as LineEndingSource[] | null
+	}
+
+	// AutoBans returns the networks currently banned after many failed authentication
+	// attempts or protocol abuses, most recent first.
+	async AutoBans(): Promise<AutoBanEntry[] | null> {
+		const fn: string = "AutoBans"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["[]","AutoBanEntry"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as AutoBanEntry[] | null
+	}
+
+	// AutoBanRemove removes the ban for a network, allowing connections from it again.
+	async AutoBanRemove(network: string): Promise<void> {
+		const fn: string = "AutoBanRemove"
+		const paramTypes: string[][] = [["string"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [network]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// MessageTrace returns the delivery history of a message, oldest first. The id is
+	// a Message-ID, with or without <>, or the ID of a message in the queue.
+	async MessageTrace(id: string): Promise<MessageEvent[] | null> {
+		const fn: string = "MessageTrace"
+		const paramTypes: string[][] = [["string"]]
+		const returnTypes: string[][] = [["[]","MessageEvent"]]
+		const params: any[] = [id]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as MessageEvent[] | null
+	}
+
+	// UsageList returns the monthly usage of accounts and domains: messages
+	// received and sent, their total sizes, and the highest total size of stored
+	// messages. Start and end are months like "2026-10", or empty. Group is empty for
+	// usage per month, account and domain, "account" for sums per month and account,
+	// or "domain" for sums per month and domain.
+	async UsageList(start: string, end: string, account: string, domain: string, group: string): Promise<Usage[] | null> {
+		const fn: string = "UsageList"
+		const paramTypes: string[][] = [["string"],["string"],["string"],["string"],["string"]]
+		const returnTypes: string[][] = [["[]","Usage"]]
+		const params: any[] = [start, end, account, domain, group]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as Usage[] | null
+	}
+
+	// Quarantined returns the messages held in quarantine, most recent first,
+	// optionally only for an account.
+	async Quarantined(accountName: string): Promise<Quarantined[] | null> {
+		const fn: string = "Quarantined"
+		const paramTypes: string[][] = [["string"]]
+		const returnTypes: string[][] = [["[]","Quarantined"]]
+		const params: any[] = [accountName]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as Quarantined[] | null
+	}
+
+	// QuarantineHeaders returns the header section of a quarantined message.
+	async QuarantineHeaders(id: number): Promise<string> {
+		const fn: string = "QuarantineHeaders"
+		const paramTypes: string[][] = [["int64"]]
+		const returnTypes: string[][] = [["string"]]
+		const params: any[] = [id]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as string
+	}
+
+	// QuarantineRelease delivers a quarantined message to the mailbox it was destined
+	// for, and removes it from the quarantine.
+	async QuarantineRelease(id: number): Promise<void> {
+		const fn: string = "QuarantineRelease"
+		const paramTypes: string[][] = [["int64"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [id]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// QuarantineRemove removes a quarantined message without delivering it.
+	async QuarantineRemove(id: number): Promise<void> {
+		const fn: string = "QuarantineRemove"
+		const paramTypes: string[][] = [["int64"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [id]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// Passkeys returns the passkeys for admin logins.
+	async Passkeys(): Promise<Passkey[] | null> {
+		const fn: string = "Passkeys"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["[]","Passkey"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as Passkey[] | null
+	}
+
+	// PasskeyRegisterPrep returns options for registering a new passkey for admin
+	// logins with navigator.credentials.create in the browser. Finish registration
+	// with PasskeyRegister.
+	async PasskeyRegisterPrep(): Promise<PasskeyCreationOptions> {
+		const fn: string = "PasskeyRegisterPrep"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["PasskeyCreationOptions"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as PasskeyCreationOptions
+	}
+
+	// PasskeyRegister finishes registration of an admin passkey started with
+	// PasskeyRegisterPrep, with the response of navigator.credentials.create.
+	async PasskeyRegister(label: string, response: PasskeyAttestation): Promise<void> {
+		const fn: string = "PasskeyRegister"
+		const paramTypes: string[][] = [["string"],["PasskeyAttestation"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [label, response]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// PasskeyRemove removes a passkey for admin logins.
+	async PasskeyRemove(id: number): Promise<void> {
+		const fn: string = "PasskeyRemove"
+		const paramTypes: string[][] = [["int64"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [id]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// APITokens returns the tokens for the admin API.
+	async APITokens(): Promise<APIToken[] | null> {
+		const fn: string = "APITokens"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["[]","APIToken"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as APIToken[] | null
+	}
+
+	// APITokenAdd adds a token for the admin API with a role, and returns the token.
+	// The token is not stored, only a hash.
+	async APITokenAdd(name: string, role: Role): Promise<string> {
+		const fn: string = "APITokenAdd"
+		const paramTypes: string[][] = [["string"],["Role"]]
+		const returnTypes: string[][] = [["string"]]
+		const params: any[] = [name, role]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as string
+	}
+
+	// APITokenRemove removes a token for the admin API.
+	async APITokenRemove(name: string): Promise<void> {
+		const fn: string = "APITokenRemove"
+		const paramTypes: string[][] = [["string"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [name]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// AuditList returns entries from the audit log of admin actions, most recent
+	// first. If source is non-empty, only entries from that source are returned:
+	// "webadmin", "adminapi" or "ctl". If max is 0, all entries are returned.
+	async AuditList(source: string, max: number): Promise<AuditEntry[] | null> {
+		const fn: string = "AuditList"
+		const paramTypes: string[][] = [["string"],["int32"]]
+		const returnTypes: string[][] = [["[]","AuditEntry"]]
+		const params: any[] = [source, max]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as AuditEntry[] | null
+	}
+
 	// AccountSettingsSave set new settings for an account that only an admin can set.
-	async AccountSettingsSave(accountName: string, maxOutgoingMessagesPerDay: number, maxFirstTimeRecipientsPerDay: number, maxMsgSize: number, firstTimeSenderDelay: boolean): Promise<void> {
+	async AccountSettingsSave(accountName: string, maxOutgoingMessagesPerDay: number, maxFirstTimeRecipientsPerDay: number, maxAliases: number, maxMsgSize: number, firstTimeSenderDelay: boolean): Promise<void> {
 		const fn: string = "AccountSettingsSave"
-		const paramTypes: string[][] = [["string"],["int32"],["int32"],["int64"],["bool"]]
+		const paramTypes: string[][] = [["string"],["int32"],["int32"],["int32"],["int64"],["bool"]]
 		const returnTypes: string[][] = []
-		const params: any[] = [accountName, maxOutgoingMessagesPerDay, maxFirstTimeRecipientsPerDay, maxMsgSize, firstTimeSenderDelay]
+		const params: any[] = [accountName, maxOutgoingMessagesPerDay, maxFirstTimeRecipientsPerDay, maxAliases, maxMsgSize, firstTimeSenderDelay]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// AccountJunkFilterSharedSave saves whether the account contributes to the shared
+	// junk filter, and the weight of the shared junk filter when classifying incoming
+	// messages for the account.
+	async AccountJunkFilterSharedSave(accountName: string, contribute: boolean, sharedWeight: number): Promise<void> {
+		const fn: string = "AccountJunkFilterSharedSave"
+		const paramTypes: string[][] = [["string"],["bool"],["float64"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [accountName, contribute, sharedWeight]
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
 	}
 
@@ -1980,6 +2978,48 @@ export class Client {
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
 	}
 
+	// LogLevelsReset resets the log levels to those in the config file, undoing
+	// changes made at runtime. Also done on SIGHUP.
+	async LogLevelsReset(): Promise<void> {
+		const fn: string = "LogLevelsReset"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = []
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// ConfigReloadPreview returns the changes in the config file mox.conf compared to
+	// the running configuration, without applying them.
+	async ConfigReloadPreview(): Promise<StaticReload> {
+		const fn: string = "ConfigReloadPreview"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["StaticReload"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as StaticReload
+	}
+
+	// ConfigReload reloads the config file mox.conf, applying changes that can take
+	// effect while running, and resetting log levels. Changes that require a restart
+	// are returned but not applied. If the config file has errors, nothing is applied.
+	// Also done on SIGHUP.
+	async ConfigReload(): Promise<StaticReload> {
+		const fn: string = "ConfigReload"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["StaticReload"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as StaticReload
+	}
+
+	// LogRecent returns log entries kept in memory that match the filter, most
+	// recent first.
+	async LogRecent(filter: LogFilter): Promise<LogEntry[] | null> {
+		const fn: string = "LogRecent"
+		const paramTypes: string[][] = [["LogFilter"]]
+		const returnTypes: string[][] = [["[]","LogEntry"]]
+		const params: any[] = [filter]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as LogEntry[] | null
+	}
+
 	// CheckUpdatesEnabled returns whether checking for updates is enabled.
 	async CheckUpdatesEnabled(): Promise<boolean> {
 		const fn: string = "CheckUpdatesEnabled"
@@ -2194,6 +3234,26 @@ export class Client {
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
 	}
 
+	// AccountFooterSave saves the footer added to messages submitted by an account.
+	// A nil footer removes it.
+	async AccountFooterSave(accountName: string, footer: Footer | null): Promise<void> {
+		const fn: string = "AccountFooterSave"
+		const paramTypes: string[][] = [["string"],["nullable","Footer"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [accountName, footer]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// DomainFooterSave saves the footer added to messages submitted with a message
+	// From address in a domain. A nil footer removes it.
+	async DomainFooterSave(domainName: string, footer: Footer | null): Promise<void> {
+		const fn: string = "DomainFooterSave"
+		const paramTypes: string[][] = [["string"],["nullable","Footer"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [domainName, footer]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
 	// RoutesSave saves global routes.
 	async RoutesSave(routes: Route[] | null): Promise<void> {
 		const fn: string = "RoutesSave"
@@ -2203,6 +3263,24 @@ export class Client {
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
 	}
 
+	// AddressRewritesSave saves the global address rewrite rules.
+	async AddressRewritesSave(rules: AddressRewrite[] | null): Promise<void> {
+		const fn: string = "AddressRewritesSave"
+		const paramTypes: string[][] = [["[]","AddressRewrite"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [rules]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// DomainAddressRewritesSave saves the address rewrite rules for a domain.
+	async DomainAddressRewritesSave(domainName: string, rules: AddressRewrite[] | null): Promise<void> {
+		const fn: string = "DomainAddressRewritesSave"
+		const paramTypes: string[][] = [["string"],["[]","AddressRewrite"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [domainName, rules]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
 	// DomainDescriptionSave saves the description for a domain.
 	async DomainDescriptionSave(domainName: string, descr: string): Promise<void> {
 		const fn: string = "DomainDescriptionSave"
@@ -2212,6 +3290,15 @@ export class Client {
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
 	}
 
+	// DomainAdminsSave saves the login addresses of domain admins for a domain.
+	async DomainAdminsSave(domainName: string, admins: string[] | null): Promise<void> {
+		const fn: string = "DomainAdminsSave"
+		const paramTypes: string[][] = [["string"],["[]","string"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [domainName, admins]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
 	// DomainClientSettingsDomainSave saves the client settings domain for a domain.
 	async DomainClientSettingsDomainSave(domainName: string, clientSettingsDomain: string): Promise<void> {
 		const fn: string = "DomainClientSettingsDomainSave"
@@ -2221,6 +3308,25 @@ export class Client {
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
 	}
 
+	// DomainSenderListsSave saves the allow/deny sender lists for incoming messages
+	// to a domain. Senders are email addresses or domains as "@domain".
+	async DomainSenderListsSave(domainName: string, allow: string[] | null, reject: string[] | null, junk: string[] | null): Promise<void> {
+		const fn: string = "DomainSenderListsSave"
+		const paramTypes: string[][] = [["string"],["[]","string"],["[]","string"],["[]","string"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [domainName, allow, reject, junk]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// DomainSpamtrapsSave saves the localparts of the spamtrap addresses of a domain.
+	async DomainSpamtrapsSave(domainName: string, localparts: string[] | null): Promise<void> {
+		const fn: string = "DomainSpamtrapsSave"
+		const paramTypes: string[][] = [["string"],["[]","string"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [domainName, localparts]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
 	// DomainLocalpartConfigSave saves the localpart catchall and case-sensitive
 	// settings for a domain.
 	async DomainLocalpartConfigSave(domainName: string, localpartCatchallSeparator: string, localpartCaseSensitive: boolean): Promise<void> {
@@ -2254,13 +3360,28 @@ export class Client {
 	}
 
 	// DomainMTASTSSave saves the MTASTS policy for a domain. If policyID is empty,
-	// no MTASTS policy is served.
-	async DomainMTASTSSave(domainName: string, policyID: string, mode: Mode, maxAge: number, mx: string[] | null): Promise<void> {
+	// no MTASTS policy is served. If the policy changes but policyID is the same as
+	// the currently configured policy ID, a new policy ID is generated, so remote
+	// mail servers fetch the new policy. The policy ID in effect is returned.
+	// 
+	// For a policy in mode testing, a non-zero enforceAfter causes the policy to be
+	// changed to mode enforce after that period without TLS failures reported.
+	async DomainMTASTSSave(domainName: string, policyID: string, mode: Mode, maxAge: number, mx: string[] | null, enforceAfter: number): Promise<string> {
 		const fn: string = "DomainMTASTSSave"
-		const paramTypes: string[][] = [["string"],["string"],["Mode"],["int64"],["[]","string"]]
-		const returnTypes: string[][] = []
-		const params: any[] = [domainName, policyID, mode, maxAge, mx]
-		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+		const paramTypes: string[][] = [["string"],["string"],["Mode"],["int64"],["[]","string"],["int64"]]
+		const returnTypes: string[][] = [["string"]]
+		const params: any[] = [domainName, policyID, mode, maxAge, mx, enforceAfter]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as string
+	}
+
+	// DomainMTASTSPromotion returns the status of automatic promotion of the MTA-STS
+	// policy of a domain from mode testing to mode enforce.
+	async DomainMTASTSPromotion(domainName: string): Promise<Status> {
+		const fn: string = "DomainMTASTSPromotion"
+		const paramTypes: string[][] = [["string"]]
+		const returnTypes: string[][] = [["Status"]]
+		const params: any[] = [domainName]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as Status
 	}
 
 	// DomainDKIMAdd adds a DKIM selector for a domain, generating a new private
@@ -2301,11 +3422,11 @@ export class Client {
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
 	}
 
-	async AliasUpdate(aliaslp: string, domainName: string, postPublic: boolean, listMembers: boolean, allowMsgFrom: boolean): Promise<void> {
+	async AliasUpdate(aliaslp: string, domainName: string, postPublic: boolean, listMembers: boolean, allowMsgFrom: boolean, ownerAddress: string, moderated: boolean): Promise<void> {
 		const fn: string = "AliasUpdate"
-		const paramTypes: string[][] = [["string"],["string"],["bool"],["bool"],["bool"]]
+		const paramTypes: string[][] = [["string"],["string"],["bool"],["bool"],["bool"],["string"],["bool"]]
 		const returnTypes: string[][] = []
-		const params: any[] = [aliaslp, domainName, postPublic, listMembers, allowMsgFrom]
+		const params: any[] = [aliaslp, domainName, postPublic, listMembers, allowMsgFrom, ownerAddress, moderated]
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
 	}
 
@@ -2332,6 +3453,58 @@ export class Client {
 		const params: any[] = [aliaslp, domainName, addresses]
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
 	}
+
+	// AdminScope returns whether the session is for the server admin or a domain
+	// admin, and for domain admins the domains they can manage.
+	async AdminScope(): Promise<AdminScope> {
+		const fn: string = "AdminScope"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["AdminScope"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as AdminScope
+	}
+
+	// DomainSetupAdd adds a new domain like DomainAdd, but without enabling DKIM
+	// signing and MTA-STS mode enforce until its DNS records have been verified with
+	// DomainSetupCheck and the setup is activated with DomainSetupActivate.
+	async DomainSetupAdd(domain: string, accountName: string, localpart: string): Promise<void> {
+		const fn: string = "DomainSetupAdd"
+		const paramTypes: string[][] = [["string"],["string"],["string"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [domain, accountName, localpart]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// DomainSetups returns the domains with a setup that has not yet been activated.
+	async DomainSetups(): Promise<DomainSetup[] | null> {
+		const fn: string = "DomainSetups"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["[]","DomainSetup"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as DomainSetup[] | null
+	}
+
+	// DomainSetupCheck checks the DNS records of a domain with a pending setup,
+	// returning the records to create and whether the setup can be activated.
+	async DomainSetupCheck(domainName: string): Promise<DomainSetupStatus> {
+		const fn: string = "DomainSetupCheck"
+		const paramTypes: string[][] = [["string"]]
+		const returnTypes: string[][] = [["DomainSetupStatus"]]
+		const params: any[] = [domainName]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as DomainSetupStatus
+	}
+
+	// DomainSetupActivate activates the pending setup of a domain after checking its
+	// DNS records: DKIM signing is enabled, and the MTA-STS policy is changed to mode
+	// enforce. The new MTA-STS policy ID is returned, the DNS record must be updated
+	// with it. Empty if there is no MTA-STS policy.
+	async DomainSetupActivate(domainName: string): Promise<string> {
+		const fn: string = "DomainSetupActivate"
+		const paramTypes: string[][] = [["string"]]
+		const returnTypes: string[][] = [["string"]]
+		const params: any[] = [domainName]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as string
+	}
 }
 
 export const defaultBaseURL = (function() {
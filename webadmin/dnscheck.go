@@ -0,0 +1,175 @@
+package webadmin
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/mjl-/sherpa"
+
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+)
+
+var (
+	metricDNSCheckErrors = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mox_dnscheck_errors",
+			Help: "Number of errors in the most recent DNS check of a domain. Only exported when enabled with MetricsSeries in mox.conf.",
+		},
+		[]string{
+			"domain",
+			"check", // dnssec, iprev, mx, tls, dane, spf, dkim, dmarc, hosttlsrpt, domaintlsrpt, mtasts, srvconf, autoconf, autodiscover
+		},
+	)
+	metricDNSCheckWarnings = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mox_dnscheck_warnings",
+			Help: "Number of warnings in the most recent DNS check of a domain. Only exported when enabled with MetricsSeries in mox.conf.",
+		},
+		[]string{
+			"domain",
+			"check",
+		},
+	)
+)
+
+// Number of errors per domain in the most recent DNS checks, for health checks.
+var dnscheckErrors = struct {
+	sync.Mutex
+	domains map[string]int
+}{domains: map[string]int{}}
+
+// DNSCheckErrors returns the number of errors per domain found by the most recent
+// DNS check of each domain. Only domains with errors are included. Only
+// populated when periodic DNS checks are enabled with MetricsSeries in mox.conf.
+func DNSCheckErrors() map[string]int {
+	dnscheckErrors.Lock()
+	defer dnscheckErrors.Unlock()
+	r := map[string]int{}
+	for d, n := range dnscheckErrors.domains {
+		if n > 0 {
+			r[d] = n
+		}
+	}
+	return r
+}
+
+// dnscheckMetricsUpdate sets the metrics for the results of a DNS check of a
+// domain, if enabled.
+func dnscheckMetricsUpdate(domain dns.Domain, r CheckResult) {
+	if ms := mox.Conf.Static.MetricsSeries; ms == nil || ms.DNSCheckInterval == 0 {
+		return
+	}
+
+	results := []struct {
+		check string
+		r     Result
+	}{
+		{"dnssec", r.DNSSEC.Result},
+		{"iprev", r.IPRev.Result},
+		{"mx", r.MX.Result},
+		{"tls", r.TLS.Result},
+		{"dane", r.DANE.Result},
+		{"spf", r.SPF.Result},
+		{"dkim", r.DKIM.Result},
+		{"dmarc", r.DMARC.Result},
+		{"hosttlsrpt", r.HostTLSRPT.Result},
+		{"domaintlsrpt", r.DomainTLSRPT.Result},
+		{"mtasts", r.MTASTS.Result},
+		{"srvconf", r.SRVConf.Result},
+		{"autoconf", r.Autoconf.Result},
+		{"autodiscover", r.Autodiscover.Result},
+	}
+	var nerrors int
+	for _, e := range results {
+		metricDNSCheckErrors.WithLabelValues(domain.Name(), e.check).Set(float64(len(e.r.Errors)))
+		metricDNSCheckWarnings.WithLabelValues(domain.Name(), e.check).Set(float64(len(e.r.Warnings)))
+		nerrors += len(e.r.Errors)
+	}
+
+	dnscheckErrors.Lock()
+	dnscheckErrors.domains[domain.Name()] = nerrors
+	dnscheckErrors.Unlock()
+}
+
+// StartDNSCheck periodically checks the DNS records of all domains for metrics,
+// if enabled in the configuration.
+func StartDNSCheck() {
+	log := mlog.New("webadmin", nil)
+
+	go func() {
+		timer := time.NewTimer(5 * time.Minute)
+		defer timer.Stop()
+		for {
+			select {
+			case <-mox.Shutdown.Done():
+				return
+			case <-timer.C:
+			}
+
+			interval := time.Hour
+			if ms := mox.Conf.Static.MetricsSeries; ms != nil && ms.DNSCheckInterval > 0 {
+				interval = ms.DNSCheckInterval
+				dnscheckAll(log.WithCid(mox.Cid()))
+			}
+			timer.Reset(interval)
+		}
+	}()
+}
+
+// dnscheckAll checks all domains, replacing the DNS check metrics. Domains
+// removed from the configuration no longer have series afterwards.
+func dnscheckAll(log mlog.Log) {
+	metricDNSCheckErrors.Reset()
+	metricDNSCheckWarnings.Reset()
+	dnscheckErrors.Lock()
+	dnscheckErrors.domains = map[string]int{}
+	dnscheckErrors.Unlock()
+
+	for _, name := range mox.Conf.Domains() {
+		d, err := dns.ParseDomain(name)
+		if err != nil {
+			log.Errorx("parsing domain for dns check", err, slog.String("domain", name))
+			continue
+		}
+		if domConf, ok := mox.Conf.Domain(d); !ok || domConf.ReportsOnly {
+			continue
+		}
+		dnscheckDomain(log, d)
+	}
+}
+
+func dnscheckDomain(log mlog.Log, domain dns.Domain) {
+	defer func() {
+		x := recover()
+		if x == nil {
+			return
+		}
+		// Errors are panics with a sherpa.Error, e.g. for a domain removed in the
+		// meantime, which we don't treat as a crash.
+		if err, ok := x.(*sherpa.Error); ok {
+			log.Errorx("dns check for metrics", err, slog.Any("domain", domain))
+			return
+		}
+		log.Error("recover from panic", slog.Any("panic", x))
+		debug.PrintStack()
+		metrics.PanicInc(metrics.Webadmin)
+	}()
+
+	resolver := dns.StrictResolver{Pkg: "check", Log: log.Logger}
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	ctx, cancel := context.WithTimeout(mox.Shutdown, 30*time.Second)
+	defer cancel()
+	r := checkDomain(ctx, resolver, dialer, domain.Name())
+	dnscheckMetricsUpdate(domain, r)
+	log.Debug("dns check for metrics", slog.Any("domain", domain))
+}
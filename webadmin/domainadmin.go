@@ -0,0 +1,219 @@
+package webadmin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/mox-"
+)
+
+// Domain admins are configured per domain, and log in to the admin web interface
+// with the credentials of their account. They can only call the API methods in
+// domainAdminMethods, with parameters that reference their own domains, accounts
+// with a default domain in their domains, and addresses in their domains. Methods
+// that list domains, accounts or transports only return what a domain admin can
+// see.
+
+// domainAdminParam is the type of a parameter that is checked for domain admins.
+type domainAdminParam int
+
+const (
+	paramDomain       domainAdminParam = iota // Domain name.
+	paramDomainOpt                            // Domain name, or empty.
+	paramAccount                              // Name of account, its default domain must be one of the domains.
+	paramAddress                              // Email address, or "@domain" for catchall.
+	paramAddresses                            // List of email addresses.
+	paramAliasMembers                         // Alias (config.Alias) with member addresses.
+)
+
+// domainAdminMethods are the methods domain admins can call, with the parameters
+// (by index) that must reference their domains.
+var domainAdminMethods = map[string]map[int]domainAdminParam{
+	"Logout":       nil,
+	"AdminScope":   nil,
+	"Domains":      nil,
+	"Accounts":     nil,
+	"Transports":   nil,
+	"ParseDomain":  nil,
+	"LookupIP":     nil,
+	"DomainSetups": nil,
+
+	"Domain":              {0: paramDomain},
+	"DomainConfig":        {0: paramDomain},
+	"DomainLocalparts":    {0: paramDomain},
+	"CheckDomain":         {0: paramDomain},
+	"DomainRecords":       {0: paramDomain},
+	"ClientConfigsDomain": {0: paramDomain},
+
+	"DomainDescriptionSave":          {0: paramDomain},
+	"DomainClientSettingsDomainSave": {0: paramDomain},
+	"DomainLocalpartConfigSave":      {0: paramDomain},
+	"DomainSenderListsSave":          {0: paramDomain},
+	"DomainSpamtrapsSave":            {0: paramDomain},
+	"DomainFooterSave":               {0: paramDomain},
+	"DomainDMARCAddressSave":         {0: paramDomain, 2: paramDomainOpt, 3: paramAccount},
+	"DomainTLSRPTAddressSave":        {0: paramDomain, 2: paramDomainOpt, 3: paramAccount},
+	"DomainMTASTSSave":               {0: paramDomain},
+	"DomainMTASTSPromotion":          {0: paramDomain},
+	"DomainDKIMAdd":                  {0: paramDomain},
+	"DomainDKIMRemove":               {0: paramDomain},
+	"DomainDKIMSave":                 {0: paramDomain},
+	"DomainSetupCheck":               {0: paramDomain},
+	"DomainSetupActivate":            {0: paramDomain},
+
+	"DMARCReports":    {2: paramDomain},
+	"DMARCReportID":   {0: paramDomain},
+	"DMARCSummaries":  {2: paramDomain},
+	"TLSReports":      {2: paramDomain},
+	"TLSReportID":     {0: paramDomain},
+	"TLSRPTSummaries": {2: paramDomain},
+
+	"Account":                       {0: paramAccount},
+	"AccountAdd":                    {1: paramAddress},
+	"AccountRemove":                 {0: paramAccount},
+	"AddressAdd":                    {0: paramAddress, 1: paramAccount},
+	"AddressRemove":                 {0: paramAddress},
+	"SetPassword":                   {0: paramAccount},
+	"AccountPasswordChangeRequired": {0: paramAccount},
+	"AccountPasswordChangeRequire":  {0: paramAccount},
+	"AccountProtocolSessions":       {0: paramAccount},
+	"AccountProtocolSessionClose":   {0: paramAccount},
+	"AccountTOTPReset":              {0: paramAccount},
+	"AccountPasskeys":               {0: paramAccount},
+	"AccountPasskeysReset":          {0: paramAccount},
+	"AccountDeletions":              nil,
+	"AccountDeletionRequest":        {0: paramAccount},
+	"AccountDeletionCancel":         {0: paramAccount},
+	"AccountDeletionPurge":          {0: paramAccount},
+	"AccountFooterSave":             {0: paramAccount},
+	"SubmissionIncidents":           {0: paramAccount},
+	"SubmissionThrottleClear":       {0: paramAccount},
+	"Quarantined":                   nil,
+	"QuarantineHeaders":             nil,
+	"QuarantineRelease":             nil,
+	"QuarantineRemove":              nil,
+	"SpamtrapHits":                  nil,
+
+	"AliasAdd":             {1: paramDomain, 2: paramAliasMembers},
+	"AliasUpdate":          {1: paramDomain},
+	"AliasRemove":          {1: paramDomain},
+	"AliasAddressesAdd":    {1: paramDomain, 3: paramAddresses},
+	"AliasAddressesRemove": {1: paramDomain},
+}
+
+// domainAdminCheck returns an error if a domain admin, managing domains, cannot
+// call method with the parameters in the sherpa request body.
+func domainAdminCheck(method string, body []byte, domains []dns.Domain) error {
+	if len(domains) == 0 {
+		if method == "Logout" {
+			return nil
+		}
+		return fmt.Errorf("not a domain admin of any domain")
+	}
+	checks, ok := domainAdminMethods[method]
+	if !ok {
+		return fmt.Errorf("method %s not allowed for domain admins", method)
+	}
+	if len(checks) == 0 {
+		return nil
+	}
+
+	var req struct {
+		Params []json.RawMessage `json:"params"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return fmt.Errorf("parsing request: %v", err)
+	}
+
+	checkDomain := func(s string) error {
+		d, err := dns.ParseDomain(s)
+		if err != nil {
+			return fmt.Errorf("parsing domain %q: %v", s, err)
+		}
+		if !slices.Contains(domains, d) {
+			return fmt.Errorf("domain %s not managed by domain admin", d)
+		}
+		return nil
+	}
+	checkAddress := func(s string) error {
+		// Parsing is done by the method, we only need the domain.
+		t := strings.Split(s, "@")
+		if len(t) < 2 {
+			return fmt.Errorf("address %q must include a domain", s)
+		}
+		return checkDomain(t[len(t)-1])
+	}
+
+	for i, p := range checks {
+		if i >= len(req.Params) {
+			return fmt.Errorf("missing parameter %d", i)
+		}
+		var err error
+		switch p {
+		case paramDomain, paramDomainOpt:
+			var s string
+			if err = json.Unmarshal(req.Params[i], &s); err == nil && (s != "" || p == paramDomain) {
+				err = checkDomain(s)
+			}
+		case paramAccount:
+			var s string
+			if err = json.Unmarshal(req.Params[i], &s); err == nil {
+				if acc, ok := mox.Conf.Account(s); !ok || !slices.Contains(domains, acc.DNSDomain) {
+					err = fmt.Errorf("account %q not managed by domain admin", s)
+				}
+			}
+		case paramAddress:
+			var s string
+			if err = json.Unmarshal(req.Params[i], &s); err == nil {
+				err = checkAddress(s)
+			}
+		case paramAddresses, paramAliasMembers:
+			var l []string
+			if p == paramAliasMembers {
+				var a config.Alias
+				err = json.Unmarshal(req.Params[i], &a)
+				l = a.Addresses
+			} else {
+				err = json.Unmarshal(req.Params[i], &l)
+			}
+			for _, s := range l {
+				if err == nil {
+					err = checkAddress(s)
+				}
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("parameter %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// domainAdminDomains returns whether the request is from a domain admin, and if
+// so, the domains it can manage.
+func domainAdminDomains(ctx context.Context) ([]dns.Domain, bool) {
+	reqInfo, ok := ctx.Value(requestInfoCtxKey).(requestInfo)
+	if !ok || reqInfo.LoginAddress == "" {
+		return nil, false
+	}
+	return mox.Conf.DomainAdminDomains(reqInfo.LoginAddress), true
+}
+
+// AdminScope describes what the logged in admin can manage.
+type AdminScope struct {
+	LoginAddress string       // Empty for the server admin, the login address for a domain admin.
+	Domains      []dns.Domain // For domain admins, the domains they can manage.
+}
+
+// AdminScope returns whether the session is for the server admin or a domain
+// admin, and for domain admins the domains they can manage.
+func (Admin) AdminScope(ctx context.Context) AdminScope {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	domains, _ := domainAdminDomains(ctx)
+	return AdminScope{reqInfo.LoginAddress, domains}
+}
@@ -0,0 +1,240 @@
+package webadmin
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/mjl-/mox/admindb"
+	"github.com/mjl-/mox/config"
+	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/mtasts"
+	"github.com/mjl-/mox/smtp"
+)
+
+// Domains added with the add domain wizard start without DKIM signing, and with
+// their MTA-STS policy in mode testing without automatic promotion. The wizard
+// shows the DNS records to create, and checks them until they are valid. Only
+// then can the setup be activated, enabling DKIM signing and MTA-STS mode
+// enforce.
+
+// DomainSetupRecord is a DNS record to create for a domain.
+type DomainSetupRecord struct {
+	Name  string // Absolute, with trailing dot.
+	Type  string // E.g. "MX", "TXT", "CNAME".
+	Value string // Record data. For TXT, the text without quotes, with multiple strings joined.
+	Zone  string // Record in zone file syntax.
+	Check string // Check that verifies the record: "MX", "SPF", "DKIM", "DMARC", "TLSRPT", "MTASTS". Empty for records that are not verified for the setup.
+}
+
+// DomainSetupCheck is the result of one of the DNS checks required for
+// activating a domain setup.
+type DomainSetupCheck struct {
+	Name   string // "MX", "SPF", "DKIM", "DMARC", "TLSRPT", "MTASTS".
+	Errors []string
+}
+
+// DomainSetupStatus is the state of a pending domain setup.
+type DomainSetupStatus struct {
+	Setup   admindb.DomainSetup
+	Records []DomainSetupRecord
+	Checks  []DomainSetupCheck
+	Ready   bool // Whether all checks passed, and the setup can be activated.
+}
+
+// DomainSetupAdd adds a new domain like DomainAdd, but without enabling DKIM
+// signing and MTA-STS mode enforce until its DNS records have been verified with
+// DomainSetupCheck and the setup is activated with DomainSetupActivate.
+func (Admin) DomainSetupAdd(ctx context.Context, domain, accountName, localpart string) {
+	d, err := dns.ParseDomain(domain)
+	xcheckuserf(ctx, err, "parsing domain")
+
+	// A setup for a domain that is not configured is a leftover, e.g. from a domain
+	// removed through the command-line.
+	if _, ok := mox.Conf.Domain(d); !ok {
+		err = admindb.DomainSetupRemove(ctx, d.Name())
+		xcheckf(ctx, err, "removing previous domain setup")
+	}
+
+	err = mox.DomainAdd(ctx, d, accountName, smtp.Localpart(norm.NFC.String(localpart)))
+	xcheckf(ctx, err, "adding domain")
+
+	setup := admindb.DomainSetup{Domain: d.Name()}
+	err = mox.DomainSave(ctx, d.Name(), func(dc *config.Domain) error {
+		setup.Sign = dc.DKIM.Sign
+		dc.DKIM.Sign = nil
+		if dc.MTASTS != nil {
+			setup.MTASTSEnforce = true
+			nsts := *dc.MTASTS
+			nsts.EnforceAfter = 0
+			dc.MTASTS = &nsts
+		}
+		return nil
+	})
+	xcheckf(ctx, err, "disabling dkim signing until setup is activated")
+	err = admindb.DomainSetupAdd(ctx, &setup)
+	xcheckf(ctx, err, "adding domain setup")
+}
+
+// DomainSetups returns the domains with a setup that has not yet been activated.
+func (Admin) DomainSetups(ctx context.Context) []admindb.DomainSetup {
+	l, err := admindb.DomainSetupList(ctx)
+	xcheckf(ctx, err, "listing domain setups")
+	domains, isDomainAdmin := domainAdminDomains(ctx)
+	return slices.DeleteFunc(l, func(s admindb.DomainSetup) bool {
+		d, err := dns.ParseDomain(s.Domain)
+		if err != nil {
+			return true
+		}
+		_, ok := mox.Conf.Domain(d)
+		return !ok || isDomainAdmin && !slices.Contains(domains, d)
+	})
+}
+
+// DomainSetupCheck checks the DNS records of a domain with a pending setup,
+// returning the records to create and whether the setup can be activated.
+func (Admin) DomainSetupCheck(ctx context.Context, domainName string) DomainSetupStatus {
+	resolver := dns.StrictResolver{Pkg: "check", Log: pkglog.WithContext(ctx).Logger}
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	nctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	return domainSetupCheck(nctx, resolver, dialer, domainName)
+}
+
+// DomainSetupActivate activates the pending setup of a domain after checking its
+// DNS records: DKIM signing is enabled, and the MTA-STS policy is changed to mode
+// enforce. The new MTA-STS policy ID is returned, the DNS record must be updated
+// with it. Empty if there is no MTA-STS policy.
+func (Admin) DomainSetupActivate(ctx context.Context, domainName string) (policyID string) {
+	st := Admin{}.DomainSetupCheck(ctx, domainName)
+	if !st.Ready {
+		xcheckuserf(ctx, errors.New("dns records are not yet valid"), "checking dns records")
+	}
+	return domainSetupActivate(ctx, st.Setup)
+}
+
+func domainSetupCheck(ctx context.Context, resolver dns.Resolver, dialer *net.Dialer, domainName string) (st DomainSetupStatus) {
+	log := pkglog.WithContext(ctx)
+
+	d, err := dns.ParseDomain(domainName)
+	xcheckuserf(ctx, err, "parsing domain")
+	dc, ok := mox.Conf.Domain(d)
+	if !ok {
+		xcheckuserf(ctx, errors.New("unknown domain"), "lookup domain")
+	}
+	st.Setup, err = admindb.DomainSetupGet(ctx, d.Name())
+	if errors.Is(err, admindb.ErrNotFound) {
+		xcheckuserf(ctx, errors.New("no pending setup for domain"), "get domain setup")
+	}
+	xcheckf(ctx, err, "get domain setup")
+
+	st.Records = domainSetupRecords(DomainRecords(ctx, log, d.Name()), d, mox.Conf.Static.HostnameDomain, st.Setup.MTASTSEnforce)
+
+	r := checkDomain(ctx, resolver, dialer, d.Name())
+	st.Checks = []DomainSetupCheck{
+		{"MX", r.MX.Errors},
+		{"SPF", r.SPF.Errors},
+		{"DKIM", r.DKIM.Errors},
+		{"DMARC", r.DMARC.Errors},
+	}
+	if dc.TLSRPT != nil {
+		st.Checks = append(st.Checks, DomainSetupCheck{"TLSRPT", r.DomainTLSRPT.Errors})
+	}
+	if st.Setup.MTASTSEnforce {
+		st.Checks = append(st.Checks, DomainSetupCheck{"MTASTS", r.MTASTS.Errors})
+	}
+	st.Ready = !slices.ContainsFunc(st.Checks, func(c DomainSetupCheck) bool { return len(c.Errors) > 0 })
+
+	now := time.Now()
+	err = admindb.DomainSetupChecked(ctx, d.Name(), now)
+	log.Check(err, "storing time of domain setup check", slog.Any("domain", d))
+	st.Setup.LastCheck = now
+
+	return st
+}
+
+func domainSetupActivate(ctx context.Context, setup admindb.DomainSetup) (policyID string) {
+	log := pkglog.WithContext(ctx)
+
+	err := mox.DomainSave(ctx, setup.Domain, func(dc *config.Domain) error {
+		dc.DKIM.Sign = nil
+		for _, s := range setup.Sign {
+			if _, ok := dc.DKIM.Selectors[s]; ok {
+				dc.DKIM.Sign = append(dc.DKIM.Sign, s)
+			}
+		}
+		if sts := dc.MTASTS; sts != nil && setup.MTASTSEnforce && sts.Mode != mtasts.ModeEnforce {
+			policyID = mox.MTASTSPolicyID(time.Now())
+			if policyID == sts.PolicyID {
+				policyID += "a"
+			}
+			nsts := *sts
+			nsts.PolicyID = policyID
+			nsts.Mode = mtasts.ModeEnforce
+			nsts.EnforceAfter = 0
+			dc.MTASTS = &nsts
+		} else if sts != nil {
+			policyID = sts.PolicyID
+		}
+		return nil
+	})
+	xcheckf(ctx, err, "enabling dkim signing and mta-sts enforce mode")
+
+	err = admindb.DomainSetupRemove(ctx, setup.Domain)
+	xcheckf(ctx, err, "removing domain setup")
+	log.Info("domain setup activated", slog.String("domain", setup.Domain), slog.Any("sign", setup.Sign), slog.String("mtastspolicyid", policyID))
+	return policyID
+}
+
+var txtStringRegexp = regexp.MustCompile(`"([^"]*)"`)
+
+// domainSetupRecords parses the records from lines as returned by DomainRecords.
+// Commented-out records are skipped.
+func domainSetupRecords(lines []string, domain, hostname dns.Domain, mtastsEnforce bool) []DomainSetupRecord {
+	d := domain.ASCII + "."
+	h := hostname.ASCII + "."
+
+	var l []DomainSetupRecord
+	for _, line := range lines {
+		if line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "$") {
+			continue
+		}
+		t := strings.Fields(line)
+		if len(t) < 3 {
+			continue
+		}
+		rr := DomainSetupRecord{Name: t[0], Type: t[1], Zone: line}
+		if rr.Type == "TXT" {
+			for _, m := range txtStringRegexp.FindAllStringSubmatch(line, -1) {
+				rr.Value += m[1]
+			}
+		} else {
+			rr.Value = strings.Join(t[2:], " ")
+		}
+
+		switch {
+		case rr.Type == "MX" && rr.Name == d:
+			rr.Check = "MX"
+		case rr.Type == "TXT" && (rr.Name == d || rr.Name == h) && strings.HasPrefix(rr.Value, "v=spf1"):
+			rr.Check = "SPF"
+		case rr.Type == "TXT" && strings.HasSuffix(rr.Name, "._domainkey."+d):
+			rr.Check = "DKIM"
+		case rr.Type == "TXT" && rr.Name == "_dmarc."+d:
+			rr.Check = "DMARC"
+		case rr.Type == "TXT" && rr.Name == "_smtp._tls."+d:
+			rr.Check = "TLSRPT"
+		case mtastsEnforce && (rr.Type == "TXT" && rr.Name == "_mta-sts."+d || rr.Type == "CNAME" && rr.Name == "mta-sts."+d):
+			rr.Check = "MTASTS"
+		}
+		l = append(l, rr)
+	}
+	return l
+}
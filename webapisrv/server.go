@@ -33,6 +33,7 @@ import (
 
 	"github.com/mjl-/mox/dkim"
 	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/footer"
 	"github.com/mjl-/mox/message"
 	"github.com/mjl-/mox/metrics"
 	"github.com/mjl-/mox/mlog"
@@ -40,6 +41,7 @@ import (
 	"github.com/mjl-/mox/moxio"
 	"github.com/mjl-/mox/moxvar"
 	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/sendguard"
 	"github.com/mjl-/mox/smtp"
 	"github.com/mjl-/mox/store"
 	"github.com/mjl-/mox/webapi"
@@ -55,7 +57,7 @@ var (
 	metricSubmission = promauto.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "mox_webapi_submission_total",
-			Help: "Webapi message submission results, known values (those ending with error are server errors): ok, badfrom, messagelimiterror, recipientlimiterror, queueerror, storesenterror.",
+			Help: "Webapi message submission results, known values (those ending with error are server errors): ok, badfrom, messagelimiterror, recipientlimiterror, guardrefused, queueerror, storesenterror.",
 		},
 		[]string{
 			"result",
@@ -64,7 +66,7 @@ var (
 	metricServerErrors = promauto.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "mox_webapi_errors_total",
-			Help: "Webapi server errors, known values: dkimsign, submit.",
+			Help: "Webapi server errors, known values: dkimsign, submit, footer.",
 		},
 		[]string{
 			"error",
@@ -431,6 +433,7 @@ func (s server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	acc, err = store.OpenEmailAuth(log, email, password)
 	if err != nil {
 		mox.LimiterFailedAuth.Add(remoteIP, t0, 1)
+		mox.AutoBanFailure(log, remoteIP, mox.AutoBanAuth)
 		if errors.Is(err, mox.ErrDomainNotFound) || errors.Is(err, mox.ErrAddressNotFound) || errors.Is(err, store.ErrUnknownCredentials) {
 			log.Debug("bad http basic authentication credentials")
 			metricResults.WithLabelValues(fn, "badauth").Inc()
@@ -630,6 +633,17 @@ func (s server) Send(ctx context.Context, req webapi.SendRequest) (resp webapi.S
 		return resp, webapi.Error{Code: "noRecipients", Message: "no recipients"}
 	}
 
+	// Rewrite sender and recipient addresses if configured, e.g. to masquerade an
+	// internal domain. Done after checking the original from address may be used.
+	naddr, _, err := mox.RewriteAddress(from.Address, true)
+	xcheckf(err, "rewriting from address")
+	from.Address, fromPath = naddr, naddr.Path()
+	for i, r := range recipients {
+		naddr, _, err := mox.RewriteAddress(smtp.NewAddress(r.Localpart, r.IPDomain.Domain), false)
+		xcheckf(err, "rewriting recipient address")
+		recipients[i] = naddr.Path()
+	}
+
 	// Check outgoing message rate limit.
 	xdbread(ctx, acc, func(tx *bstore.Tx) {
 		msglimit, rcptlimit, err := acc.SendLimitReached(tx, recipients)
@@ -944,10 +958,32 @@ func (s server) Send(ctx context.Context, req webapi.SendRequest) (resp webapi.S
 	cur = nil
 	xc.Flush()
 
-	// Add DKIM-Signature headers.
-	var msgPrefix string
+	// Check for signs of a compromised account.
+	if err := sendguard.Check(ctx, log, acc, "webapi", webauth.RemoteIP(log, s.isForwarded, reqInfo.Request), recipients, dataFile, xc.Size); err != nil {
+		metricSubmission.WithLabelValues("guardrefused").Inc()
+		panic(webapi.Error{Code: "submissionRefused", Message: err.Error()})
+	}
+
+	// Add footer, e.g. a legal disclaimer, if configured. The message is rewritten to
+	// a new file.
 	fd := from.Address.Domain
 	confDom, _ := mox.Conf.Domain(fd)
+	dataSize := xc.Size
+	if f := footer.Select(accConf, confDom); f != nil {
+		nf, size, err := footer.Apply(log, *f, dataFile)
+		if err != nil {
+			metricServerErrors.WithLabelValues("footer").Inc()
+		}
+		xcheckf(err, "adding footer")
+		if nf != nil {
+			defer store.CloseRemoveTempFile(log, nf, "message with footer")
+			dataFile = nf
+			dataSize = size
+		}
+	}
+
+	// Add DKIM-Signature headers.
+	var msgPrefix string
 	selectors := mox.DKIMSelectors(confDom.DKIM)
 	if len(selectors) > 0 {
 		dkimHeaders, err := dkim.Sign(ctx, log.Logger, from.Address.Localpart, fd, selectors, smtputf8, dataFile)
@@ -986,7 +1022,7 @@ func (s server) Send(ctx context.Context, req webapi.SendRequest) (resp webapi.S
 			recvRcpt = rcpt.XString(smtputf8)
 		}
 		rcptMsgPrefix := recvHdrFor(recvRcpt) + msgPrefix
-		msgSize := int64(len(rcptMsgPrefix)) + xc.Size
+		msgSize := int64(len(rcptMsgPrefix)) + dataSize
 		qm := queue.MakeMsg(fp, rcpt, xc.Has8bit, xc.SMTPUTF8, msgSize, m.MessageID, []byte(rcptMsgPrefix), req.RequireTLS, now, m.Subject)
 		qm.FromID = fromIDs[i]
 		qm.Extra = req.Extra
@@ -1050,8 +1086,8 @@ func (s server) Send(ctx context.Context, req webapi.SendRequest) (resp webapi.S
 					MailboxID:     sentmb.ID,
 					MailboxOrigID: sentmb.ID,
 					Flags:         store.Flags{Notjunk: true, Seen: true},
-					Size:          int64(len(msgPrefix)) + xc.Size,
-					MsgPrefix:     []byte(msgPrefix),
+					Size:          int64(len(msgPrefix)) + dataSize,
+					Sealed:        store.MessageSealed{MsgPrefix: []byte(msgPrefix)},
 				}
 
 				if ok, maxSize, err := acc.CanAddMessageSize(tx, sentm.Size); err != nil {
@@ -1229,13 +1265,13 @@ func (s server) MessageGet(ctx context.Context, req webapi.MessageGetRequest) (r
 
 	var msgFrom string
 	if d, err := dns.ParseDomain(m.MsgFromDomain); err == nil {
-		msgFrom = smtp.Address{Localpart: m.MsgFromLocalpart, Domain: d}.Pack(true)
+		msgFrom = smtp.Address{Localpart: m.Sealed.MsgFromLocalpart, Domain: d}.Pack(true)
 	}
 	meta := webapi.MessageMeta{
 		Size:                m.Size,
 		DSN:                 m.DSN,
 		Flags:               append(m.Flags.Strings(), m.Keywords...),
-		MailFrom:            m.MailFrom,
+		MailFrom:            m.Sealed.MailFrom,
 		MailFromValidated:   m.MailFromValidated,
 		MsgFrom:             msgFrom,
 		MsgFromValidated:    m.MsgFromValidated,
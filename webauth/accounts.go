@@ -3,9 +3,20 @@ package webauth
 import (
 	"context"
 	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
 
+	"github.com/mjl-/bstore"
+	"github.com/mjl-/sherpa"
+
+	"github.com/mjl-/mox/metrics"
 	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
 	"github.com/mjl-/mox/store"
+	"github.com/mjl-/mox/webauthn"
 )
 
 // AccountAuth is for user accounts, with username/password, and sessions stored in
@@ -14,9 +25,11 @@ var Accounts SessionAuth = accountSessionAuth{}
 
 type accountSessionAuth struct{}
 
-func (accountSessionAuth) login(ctx context.Context, log mlog.Log, username, password string) (bool, string, error) {
+func (accountSessionAuth) login(ctx context.Context, log mlog.Log, kind, username, password, totpCode string) (bool, string, error) {
 	acc, err := store.OpenEmailAuth(log, username, password)
-	if err != nil && errors.Is(err, store.ErrUnknownCredentials) {
+	if err != nil && errors.Is(err, store.ErrLoginDisabled) {
+		return false, "", &sherpa.Error{Code: "user:loginFailed", Message: err.Error()}
+	} else if err != nil && errors.Is(err, store.ErrUnknownCredentials) {
 		return false, "", nil
 	} else if err != nil {
 		return false, "", err
@@ -25,9 +38,118 @@ func (accountSessionAuth) login(ctx context.Context, log mlog.Log, username, pas
 		err := acc.Close()
 		log.Check(err, "closing account")
 	}()
+
+	enabled, _, err := acc.TOTPStatus(ctx)
+	if err != nil {
+		return false, "", fmt.Errorf("checking two-factor authentication: %v", err)
+	}
+	if enabled {
+		if totpCode == "" {
+			return false, "", errTOTPRequired
+		}
+		err := acc.TOTPCheck(ctx, totpCode)
+		if err != nil && errors.Is(err, store.ErrTOTPInvalid) {
+			log.Info("invalid two-factor authentication code", slog.String("username", username))
+			return false, "", nil
+		} else if err != nil {
+			return false, "", fmt.Errorf("checking two-factor authentication code: %v", err)
+		}
+	} else if kind != "webaccount" && TOTPRequired(acc) {
+		// Only logins to the account web interface are allowed, to set up two-factor
+		// authentication.
+		return false, "", &sherpa.Error{Code: "user:error", Message: "two-factor authentication is required for this account, set it up in the account web interface first"}
+	}
+	if kind != "webaccount" {
+		// Only logins to the account web interface are allowed, to change the password.
+		if required, err := acc.PasswordChangeRequired(ctx); err != nil {
+			return false, "", fmt.Errorf("checking password change: %v", err)
+		} else if required {
+			return false, "", &sherpa.Error{Code: "user:error", Message: "a password change is required for this account, change it in the account web interface first"}
+		}
+	}
 	return true, acc.Name, nil
 }
 
+func (accountSessionAuth) passkeyLogin(ctx context.Context, log mlog.Log, kind, rpID, origin string, challenge []byte, response PasskeyAssertion) (bool, string, string, error) {
+	// The user handle is the account name, set during registration.
+	userHandle, err := webauthn.Base64.DecodeString(response.UserHandle)
+	if err != nil || len(userHandle) == 0 {
+		return false, "", "", nil
+	}
+	acc, err := store.OpenAccount(log, string(userHandle))
+	if err != nil && errors.Is(err, store.ErrAccountUnknown) {
+		return false, "", "", nil
+	} else if err != nil {
+		return false, "", "", err
+	}
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	p, err := acc.PasskeyGet(ctx, response.CredentialID)
+	if err == bstore.ErrAbsent {
+		log.Info("unknown passkey", slog.String("account", acc.Name))
+		return false, "", "", nil
+	} else if err != nil {
+		return false, "", "", fmt.Errorf("looking up passkey: %v", err)
+	}
+	signCount, err := passkeyAssertionVerify(rpID, origin, challenge, p.Credential(), response)
+	if err != nil {
+		log.Infox("verifying passkey", err, slog.String("account", acc.Name), slog.Int64("passkeyid", p.ID))
+		return false, "", "", nil
+	}
+
+	// The login address must still belong to the account.
+	xacc, _, err := store.OpenEmailLogin(log, p.LoginAddress)
+	if err == nil {
+		if xacc.Name != acc.Name {
+			err = store.ErrUnknownCredentials
+		}
+		xerr := xacc.Close()
+		log.Check(xerr, "closing account")
+	}
+	if err != nil && errors.Is(err, store.ErrLoginDisabled) {
+		return false, "", "", &sherpa.Error{Code: "user:loginFailed", Message: err.Error()}
+	} else if err != nil && errors.Is(err, store.ErrUnknownCredentials) {
+		log.Info("login address of passkey no longer valid for account", slog.String("address", p.LoginAddress), slog.String("account", acc.Name))
+		return false, "", "", nil
+	} else if err != nil {
+		return false, "", "", fmt.Errorf("looking up login address: %v", err)
+	}
+
+	if err := acc.PasskeyUsed(ctx, p.ID, signCount); err != nil {
+		return false, "", "", fmt.Errorf("updating passkey: %v", err)
+	}
+	return true, acc.Name, p.LoginAddress, nil
+}
+
+func (accountSessionAuth) oidcLogin(ctx context.Context, log mlog.Log, kind, email string) (bool, string, string, error) {
+	acc, _, err := store.OpenEmailLogin(log, email)
+	if err != nil && errors.Is(err, store.ErrLoginDisabled) {
+		return false, "", "", &sherpa.Error{Code: "user:loginFailed", Message: err.Error()}
+	} else if err != nil && errors.Is(err, store.ErrUnknownCredentials) {
+		log.Info("no account for address from identity provider", slog.String("email", email))
+		return false, "", "", nil
+	} else if err != nil {
+		return false, "", "", err
+	}
+	err = acc.Close()
+	log.Check(err, "closing account")
+	return true, acc.Name, email, nil
+}
+
+// TOTPRequired returns whether two-factor authentication is required for the
+// account by configuration, either for the account or its domain.
+func TOTPRequired(acc *store.Account) bool {
+	conf, _ := acc.Conf()
+	if conf.RequireTOTP {
+		return true
+	}
+	dom, _ := mox.Conf.Domain(conf.DNSDomain)
+	return dom.RequireTOTP
+}
+
 func (accountSessionAuth) add(ctx context.Context, log mlog.Log, accountName string, loginAddress string) (sessionToken store.SessionToken, csrfToken store.CSRFToken, rerr error) {
 	return store.SessionAdd(ctx, log, accountName, loginAddress)
 }
@@ -43,3 +165,126 @@ func (accountSessionAuth) use(ctx context.Context, log mlog.Log, accountName str
 func (accountSessionAuth) remove(ctx context.Context, log mlog.Log, accountName string, sessionToken store.SessionToken) error {
 	return store.SessionRemove(ctx, log, accountName, sessionToken)
 }
+
+// LinkAccount verifies the credentials of another account and links it to the
+// session account accountName, giving its sessions access to the other account.
+// Errors are as for Login, failed attempts count towards the same rate limit.
+func LinkAccount(ctx context.Context, log mlog.Log, kind string, isForwarded bool, r *http.Request, accountName, username, password, totpCode string) (store.AccountLink, error) {
+	ip := RemoteIP(log, isForwarded, r)
+	if ip == nil {
+		return store.AccountLink{}, fmt.Errorf("cannot find ip for rate limit check (missing x-forwarded-for header?)")
+	}
+	start := time.Now()
+	if mox.AutoBanned(ip) || !mox.LimiterFailedAuth.Add(ip, start, 1) {
+		metrics.AuthenticationRatelimitedInc(kind)
+		return store.AccountLink{}, &sherpa.Error{Code: "user:error", Message: "too many authentication attempts"}
+	}
+
+	valid, linkName, err := Accounts.login(ctx, log, kind, username, password, totpCode)
+	var serr *sherpa.Error
+	if err != nil && errors.Is(err, errTOTPRequired) {
+		// Counted as failure, like in Login.
+		mox.AutoBanFailure(log, ip, mox.AutoBanAuth)
+		time.Sleep(BadAuthDelay)
+		return store.AccountLink{}, &sherpa.Error{Code: "user:totpRequired", Message: err.Error()}
+	} else if err != nil && errors.As(err, &serr) {
+		return store.AccountLink{}, serr
+	} else if err != nil {
+		return store.AccountLink{}, fmt.Errorf("evaluating credentials: %v", err)
+	} else if !valid {
+		mox.AutoBanFailure(log, ip, mox.AutoBanAuth)
+		time.Sleep(BadAuthDelay)
+		return store.AccountLink{}, &sherpa.Error{Code: "user:loginFailed", Message: "invalid credentials"}
+	}
+	mox.LimiterFailedAuth.Reset(ip, start)
+	if linkName == accountName {
+		return store.AccountLink{}, &sherpa.Error{Code: "user:error", Message: "cannot link account to itself"}
+	}
+
+	lacc, err := store.OpenAccount(log, linkName)
+	if err != nil {
+		return store.AccountLink{}, err
+	}
+	credentials, err := lacc.CredentialsFingerprint(ctx)
+	xerr := lacc.Close()
+	log.Check(xerr, "closing account")
+	if err != nil {
+		return store.AccountLink{}, fmt.Errorf("credentials fingerprint of linked account: %v", err)
+	}
+
+	acc, err := store.OpenAccount(log, accountName)
+	if err != nil {
+		return store.AccountLink{}, err
+	}
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+	return acc.AccountLinkAdd(ctx, linkName, username, credentials)
+}
+
+// ActiveAccount returns the account to use for a request of an authenticated
+// session of accountName, and the login address for it. If the "<kind>account"
+// cookie, set with SetActiveAccount, names an account linked to the session
+// account, the linked account is returned with the address used when linking.
+// Otherwise, e.g. after the link was removed, or if the link is no longer valid
+// because the credentials of the linked account changed or its logins are
+// disabled, the session account is returned.
+func ActiveAccount(ctx context.Context, log mlog.Log, kind string, r *http.Request, accountName, loginAddress string) (string, string, error) {
+	cookie, _ := r.Cookie(kind + "account")
+	if cookie == nil || cookie.Value == "" {
+		return accountName, loginAddress, nil
+	}
+	name, err := url.QueryUnescape(cookie.Value)
+	if err != nil || name == accountName {
+		return accountName, loginAddress, nil
+	}
+	if _, ok := mox.Conf.Account(name); !ok {
+		log.Debug("linked account does not exist, using session account", slog.String("linkedaccount", name))
+		return accountName, loginAddress, nil
+	}
+
+	acc, err := store.OpenAccount(log, accountName)
+	if err != nil {
+		return "", "", err
+	}
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+	link, err := acc.AccountLinkGet(ctx, name)
+	if err == bstore.ErrAbsent {
+		log.Debug("account not linked, using session account", slog.String("linkedaccount", name))
+		return accountName, loginAddress, nil
+	} else if err != nil {
+		return "", "", fmt.Errorf("looking up linked account: %v", err)
+	}
+	lacc, err := store.AccountLinkOpen(ctx, log, link)
+	if err != nil && (errors.Is(err, store.ErrLoginDisabled) || errors.Is(err, store.ErrAccountLinkInvalid) || errors.Is(err, store.ErrAccountUnknown)) {
+		log.Debugx("linked account not usable, using session account", err, slog.String("linkedaccount", name))
+		return accountName, loginAddress, nil
+	} else if err != nil {
+		return "", "", fmt.Errorf("open linked account: %v", err)
+	}
+	err = lacc.Close()
+	log.Check(err, "closing account")
+	return link.Account, link.LoginAddress, nil
+}
+
+// SetActiveAccount sets the cookie with the linked account to use for requests of
+// a session, see ActiveAccount. An empty accountName clears the cookie, making
+// the session account active again.
+func SetActiveAccount(kind, cookiePath string, isForwarded bool, w http.ResponseWriter, r *http.Request, accountName string) {
+	c := &http.Cookie{
+		Name:     kind + "account",
+		Value:    url.QueryEscape(accountName),
+		Path:     cookiePath,
+		Secure:   isHTTPS(isForwarded, r),
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	}
+	if accountName == "" {
+		c.MaxAge = -1 // Delete cookie.
+	}
+	http.SetCookie(w, c)
+}
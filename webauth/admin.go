@@ -3,9 +3,14 @@ package webauth
 import (
 	"context"
 	cryptorand "crypto/rand"
+	"encoding/base32"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"os"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -15,12 +20,18 @@ import (
 
 	"github.com/mjl-/mox/mlog"
 	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/smtp"
 	"github.com/mjl-/mox/store"
+	"github.com/mjl-/mox/totp"
+	"github.com/mjl-/mox/webauthn"
 )
 
 // Admin is for admin logins, with authentication by password, and sessions only
 // stored in memory only, with lifetime 12 hour after last use, with a maximum of
-// 10 active sessions.
+// 10 active sessions per login.
+//
+// Domain admins, configured per domain, log in with the login address and
+// password of their account. Their sessions have a non-empty login address.
 var Admin SessionAuth = &adminSessionAuth{
 	sessions: map[store.SessionToken]adminSession{},
 }
@@ -32,14 +43,37 @@ type adminSession struct {
 	sessionToken store.SessionToken
 	csrfToken    store.CSRFToken
 	expires      time.Time
+	accountName  string // Empty for the server admin.
+	loginAddress string // Empty for the server admin.
 }
 
 type adminSessionAuth struct {
 	sync.Mutex
-	sessions map[store.SessionToken]adminSession
+	sessions        map[store.SessionToken]adminSession
+	totpLastCounter int64 // Last used TOTP time step, to prevent reuse of codes.
 }
 
-func (a *adminSessionAuth) login(ctx context.Context, log mlog.Log, username, password string) (bool, string, error) {
+// AdminTOTPPath returns the path of the file with the TOTP secret for admin
+// logins, for two-factor authentication. If the file exists, a TOTP code is
+// required for logging in to the admin web interface.
+func AdminTOTPPath() string {
+	p := mox.ConfigDirPath(mox.Conf.Static.AdminPasswordFile)
+	if p == "" {
+		return ""
+	}
+	return p + ".totp"
+}
+
+func (a *adminSessionAuth) login(ctx context.Context, log mlog.Log, kind, username, password, totpCode string) (bool, string, error) {
+	if username != "" {
+		// Domain admin, with the credentials of their account.
+		if len(mox.Conf.DomainAdminDomains(username)) == 0 {
+			log.Info("login address is not a domain admin", slog.String("username", username))
+			return false, "", nil
+		}
+		return Accounts.login(ctx, log, kind, username, password, totpCode)
+	}
+
 	a.Lock()
 	defer a.Unlock()
 
@@ -58,6 +92,25 @@ func (a *adminSessionAuth) login(ctx context.Context, log mlog.Log, username, pa
 		return false, "", nil
 	}
 
+	buf, err = os.ReadFile(AdminTOTPPath())
+	if err != nil && os.IsNotExist(err) {
+		return true, "", nil
+	} else if err != nil {
+		return false, "", fmt.Errorf("reading totp file: %v", err)
+	}
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimSpace(string(buf)))
+	if err != nil {
+		return false, "", fmt.Errorf("parsing totp secret: %v", err)
+	}
+	if totpCode == "" {
+		return false, "", errTOTPRequired
+	}
+	counter, ok := totp.Verify(secret, totpCode, time.Now(), a.totpLastCounter)
+	if !ok {
+		log.Info("invalid two-factor authentication code for admin login")
+		return false, "", nil
+	}
+	a.totpLastCounter = counter
 	return true, "", nil
 }
 
@@ -72,14 +125,20 @@ func (a *adminSessionAuth) add(ctx context.Context, log mlog.Log, accountName st
 		}
 	}
 
-	// Ensure we have at most 10 sessions.
-	if len(a.sessions) > 10 {
-		var oldest *store.SessionToken
-		for _, s := range a.sessions {
-			if oldest == nil || s.expires.Before(a.sessions[*oldest].expires) {
-				oldest = &s.sessionToken
-			}
+	// Ensure we have at most 10 sessions for this login. Logins of domain admins
+	// cannot push out sessions of the server admin.
+	var n int
+	var oldest *store.SessionToken
+	for _, s := range a.sessions {
+		if s.loginAddress != loginAddress {
+			continue
+		}
+		n++
+		if oldest == nil || s.expires.Before(a.sessions[*oldest].expires) {
+			oldest = &s.sessionToken
 		}
+	}
+	if n > 10 {
 		delete(a.sessions, *oldest)
 	}
 
@@ -95,7 +154,7 @@ func (a *adminSessionAuth) add(ctx context.Context, log mlog.Log, accountName st
 	csrfToken = store.CSRFToken(base64.RawURLEncoding.EncodeToString(csrfData[:]))
 
 	// Register session.
-	a.sessions[sessionToken] = adminSession{sessionToken, csrfToken, time.Now().Add(adminSessionLifetime)}
+	a.sessions[sessionToken] = adminSession{sessionToken, csrfToken, time.Now().Add(adminSessionLifetime), accountName, loginAddress}
 	return sessionToken, csrfToken, nil
 }
 
@@ -110,10 +169,12 @@ func (a *adminSessionAuth) use(ctx context.Context, log mlog.Log, accountName st
 		return "", fmt.Errorf("session expired")
 	} else if csrfToken != "" && csrfToken != s.csrfToken {
 		return "", fmt.Errorf("mismatch between csrf and session tokens")
+	} else if accountName != s.accountName {
+		return "", fmt.Errorf("mismatch between account and session")
 	}
 	s.expires = time.Now().Add(adminSessionLifetime)
 	a.sessions[sessionToken] = s
-	return "", nil
+	return s.loginAddress, nil
 }
 
 func (a *adminSessionAuth) remove(ctx context.Context, log mlog.Log, accountName string, sessionToken store.SessionToken) error {
@@ -126,3 +187,189 @@ func (a *adminSessionAuth) remove(ctx context.Context, log mlog.Log, accountName
 	delete(a.sessions, sessionToken)
 	return nil
 }
+
+// AdminPasskeyUserID is the user ID (returned as user handle) of admin passkeys.
+const AdminPasskeyUserID = "admin"
+
+// adminPasskey is a passkey for admin logins, stored in the admin passkeys file.
+type adminPasskey struct {
+	ID           int64
+	CredentialID string
+	Label        string
+	PublicKey    []byte
+	SignCount    uint32
+	Created      time.Time
+	LastUsed     time.Time
+}
+
+// Serializes reading/writing the admin passkeys file.
+var adminPasskeysLock sync.Mutex
+
+// AdminPasskeysPath returns the path of the JSON file with passkeys for admin
+// logins. Removing the file removes all admin passkeys.
+func AdminPasskeysPath() string {
+	p := mox.ConfigDirPath(mox.Conf.Static.AdminPasswordFile)
+	if p == "" {
+		return ""
+	}
+	return p + ".passkeys"
+}
+
+func adminPasskeysRead() ([]adminPasskey, error) {
+	buf, err := os.ReadFile(AdminPasskeysPath())
+	if err != nil && os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading admin passkeys: %v", err)
+	}
+	var l []adminPasskey
+	if err := json.Unmarshal(buf, &l); err != nil {
+		return nil, fmt.Errorf("parsing admin passkeys: %v", err)
+	}
+	return l, nil
+}
+
+func adminPasskeysWrite(l []adminPasskey) error {
+	buf, err := json.MarshalIndent(l, "", "\t")
+	if err != nil {
+		return fmt.Errorf("marshal admin passkeys: %v", err)
+	}
+	if err := os.WriteFile(AdminPasskeysPath(), buf, 0660); err != nil {
+		return fmt.Errorf("writing admin passkeys: %v", err)
+	}
+	return nil
+}
+
+// AdminPasskeys returns the passkeys for admin logins. The CredentialID and
+// LoginAddress fields are not set.
+func AdminPasskeys() ([]store.Passkey, error) {
+	adminPasskeysLock.Lock()
+	defer adminPasskeysLock.Unlock()
+
+	l, err := adminPasskeysRead()
+	if err != nil {
+		return nil, err
+	}
+	var r []store.Passkey
+	for _, p := range l {
+		r = append(r, store.Passkey{ID: p.ID, Label: p.Label, Created: p.Created, LastUsed: p.LastUsed})
+	}
+	return r, nil
+}
+
+// AdminPasskeyCredentialIDs returns the base64url encoded credential IDs of the
+// admin passkeys, to prevent registering the same authenticator twice.
+func AdminPasskeyCredentialIDs() ([]string, error) {
+	adminPasskeysLock.Lock()
+	defer adminPasskeysLock.Unlock()
+
+	l, err := adminPasskeysRead()
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, p := range l {
+		ids = append(ids, p.CredentialID)
+	}
+	return ids, nil
+}
+
+// AdminPasskeyAdd adds a verified credential as admin passkey.
+func AdminPasskeyAdd(label string, cred webauthn.Credential) error {
+	label = strings.TrimSpace(label)
+	if label == "" {
+		return fmt.Errorf("%w: label required", store.ErrPasskeyParam)
+	}
+
+	adminPasskeysLock.Lock()
+	defer adminPasskeysLock.Unlock()
+
+	l, err := adminPasskeysRead()
+	if err != nil {
+		return err
+	}
+	credID := webauthn.Base64.EncodeToString(cred.ID)
+	var id int64
+	for _, p := range l {
+		if p.CredentialID == credID {
+			return fmt.Errorf("%w: passkey already registered", store.ErrPasskeyParam)
+		}
+		id = max(id, p.ID)
+	}
+	l = append(l, adminPasskey{id + 1, credID, label, cred.PublicKey, cred.SignCount, time.Now(), time.Time{}})
+	return adminPasskeysWrite(l)
+}
+
+// AdminPasskeyRemove removes an admin passkey.
+func AdminPasskeyRemove(id int64) error {
+	adminPasskeysLock.Lock()
+	defer adminPasskeysLock.Unlock()
+
+	l, err := adminPasskeysRead()
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(l, func(p adminPasskey) bool { return p.ID == id })
+	if i < 0 {
+		return fmt.Errorf("%w: no such passkey", store.ErrPasskeyParam)
+	}
+	return adminPasskeysWrite(slices.Delete(l, i, i+1))
+}
+
+func (a *adminSessionAuth) passkeyLogin(ctx context.Context, log mlog.Log, kind, rpID, origin string, challenge []byte, response PasskeyAssertion) (bool, string, string, error) {
+	if userHandle, err := webauthn.Base64.DecodeString(response.UserHandle); err != nil || string(userHandle) != AdminPasskeyUserID {
+		return false, "", "", nil
+	}
+
+	adminPasskeysLock.Lock()
+	defer adminPasskeysLock.Unlock()
+
+	l, err := adminPasskeysRead()
+	if err != nil {
+		return false, "", "", err
+	}
+	i := slices.IndexFunc(l, func(p adminPasskey) bool { return p.CredentialID == response.CredentialID })
+	if i < 0 {
+		log.Info("unknown admin passkey")
+		return false, "", "", nil
+	}
+	p := l[i]
+	id, _ := webauthn.Base64.DecodeString(p.CredentialID)
+	signCount, err := passkeyAssertionVerify(rpID, origin, challenge, webauthn.Credential{ID: id, PublicKey: p.PublicKey, SignCount: p.SignCount}, response)
+	if err != nil {
+		log.Infox("verifying admin passkey", err, slog.Int64("passkeyid", p.ID))
+		return false, "", "", nil
+	}
+	l[i].SignCount = signCount
+	l[i].LastUsed = time.Now()
+	if err := adminPasskeysWrite(l); err != nil {
+		return false, "", "", err
+	}
+	return true, "", "", nil
+}
+
+func (a *adminSessionAuth) oidcLogin(ctx context.Context, log mlog.Log, kind, email string) (bool, string, string, error) {
+	addr, err := smtp.ParseAddress(email)
+	if err != nil {
+		return false, "", "", nil
+	}
+	for _, s := range mox.Conf.Static.OIDC.Admins {
+		if x, err := smtp.ParseAddress(s); err == nil && strings.EqualFold(x.String(), addr.String()) {
+			return true, "", "", nil
+		}
+	}
+	if len(mox.Conf.DomainAdminDomains(email)) > 0 {
+		acc, _, err := store.OpenEmailLogin(log, email)
+		if err != nil && errors.Is(err, store.ErrUnknownCredentials) {
+			log.Info("no account for domain admin address from identity provider", slog.String("email", email))
+			return false, "", "", nil
+		} else if err != nil {
+			return false, "", "", err
+		}
+		err = acc.Close()
+		log.Check(err, "closing account")
+		return true, acc.Name, email, nil
+	}
+	log.Info("address from identity provider not allowed as admin", slog.String("email", email))
+	return false, "", "", nil
+}
@@ -0,0 +1,174 @@
+package webauth
+
+import (
+	"context"
+	cryptorand "crypto/rand"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mjl-/sherpa"
+
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/oidc"
+	"github.com/mjl-/mox/store"
+)
+
+// Time for finishing a login at the identity provider.
+const oidcTimeout = 10 * time.Minute
+
+// oidcLoginState is a pending login through the identity provider.
+type oidcLoginState struct {
+	kind         string // webadmin, webaccount, webmail.
+	nonce        string
+	codeVerifier string
+	redirectURI  string
+	expires      time.Time
+}
+
+// Pending logins, keyed by state. States are removed when used.
+var oidcLoginStates = struct {
+	sync.Mutex
+	m map[string]oidcLoginState
+}{m: map[string]oidcLoginState{}}
+
+func oidcRandom() string {
+	var buf [16]byte
+	if _, err := cryptorand.Read(buf[:]); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf[:])
+}
+
+// OIDCEnabled returns whether logins through an OpenID Connect identity provider
+// are configured.
+func OIDCEnabled() bool {
+	return mox.Conf.Static.OIDC != nil
+}
+
+// OIDCLoginPrep starts a login through the identity provider, returning the URL
+// to send the browser to. After logging in, the identity provider sends the
+// browser back to cookiePath with "code" and "state" query string parameters, to
+// be passed to OIDCLogin. The state is also set as cookie, so only the browser
+// that started the login can finish it.
+func OIDCLoginPrep(ctx context.Context, log mlog.Log, kind, cookiePath string, isForwarded bool, w http.ResponseWriter, r *http.Request) (string, error) {
+	p := oidc.Get(mox.Conf.Static.OIDC)
+	if p == nil {
+		return "", &sherpa.Error{Code: "user:error", Message: "single sign-on not configured"}
+	}
+
+	_, origin := passkeyRP(isForwarded, r)
+	st := oidcLoginState{
+		kind:         kind,
+		nonce:        oidcRandom(),
+		codeVerifier: oidcRandom() + oidcRandom(), // RFC 7636 section 4.1 requires at least 43 characters.
+		redirectURI:  origin + cookiePath,
+		expires:      time.Now().Add(oidcTimeout),
+	}
+	state := oidcRandom()
+	authURL, err := p.AuthURL(ctx, log, st.redirectURI, state, st.nonce, st.codeVerifier)
+	if err != nil {
+		return "", err
+	}
+
+	oidcLoginStates.Lock()
+	now := time.Now()
+	for k, s := range oidcLoginStates.m {
+		if now.After(s.expires) {
+			delete(oidcLoginStates.m, k)
+		}
+	}
+	// Prevent unbounded growth from unfinished logins.
+	if len(oidcLoginStates.m) >= 1000 {
+		for k := range oidcLoginStates.m {
+			delete(oidcLoginStates.m, k)
+			break
+		}
+	}
+	oidcLoginStates.m[state] = st
+	oidcLoginStates.Unlock()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     kind + "oidc",
+		Value:    state,
+		Path:     cookiePath,
+		Secure:   isHTTPS(isForwarded, r),
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+		MaxAge:   int(oidcTimeout / time.Second),
+	})
+	return authURL, nil
+}
+
+// OIDCLogin finishes a login through the identity provider, started with
+// OIDCLoginPrep. The authorization code is exchanged for an ID token, and the
+// email address in it must be a login address of an account, or for admin, one
+// of the configured admin addresses. Like Login, a session cookie is set and
+// the CSRF token returned. The identity provider is responsible for two-factor
+// authentication.
+func OIDCLogin(ctx context.Context, log mlog.Log, sessionAuth SessionAuth, kind, cookiePath string, isForwarded bool, w http.ResponseWriter, r *http.Request, loginToken, code, state string) (store.CSRFToken, error) {
+	ip, start, err := loginCheck(log, kind, isForwarded, r, loginToken)
+	if err != nil {
+		return "", err
+	}
+
+	var authResult string
+	defer func() {
+		metrics.AuthenticationInc(kind, "oidc", authResult)
+	}()
+
+	p := oidc.Get(mox.Conf.Static.OIDC)
+	if p == nil {
+		authResult = "error"
+		return "", &sherpa.Error{Code: "user:error", Message: "single sign-on not configured"}
+	}
+
+	stateCookie, _ := r.Cookie(kind + "oidc")
+	http.SetCookie(w, &http.Cookie{
+		Name:     kind + "oidc",
+		Path:     cookiePath,
+		Secure:   isHTTPS(isForwarded, r),
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+		MaxAge:   -1, // Delete cookie.
+	})
+	oidcLoginStates.Lock()
+	st, ok := oidcLoginStates.m[state]
+	delete(oidcLoginStates.m, state)
+	oidcLoginStates.Unlock()
+	if !ok || st.kind != kind || time.Now().After(st.expires) || stateCookie == nil || stateCookie.Value != state {
+		authResult = "error"
+		return "", &sherpa.Error{Code: "user:error", Message: "unknown or expired single sign-on login, try again"}
+	}
+
+	email, err := p.Exchange(ctx, log, code, st.redirectURI, st.codeVerifier, st.nonce)
+	var valid bool
+	var accountName, loginAddress string
+	if err == nil {
+		valid, accountName, loginAddress, err = sessionAuth.oidcLogin(ctx, log, kind, email)
+	}
+	var serr *sherpa.Error
+	if err != nil && errors.As(err, &serr) {
+		authResult = "error"
+		return "", serr
+	} else if err != nil && !errors.Is(err, oidc.ErrToken) {
+		authResult = "error"
+		log.Errorx("single sign-on login", err)
+		return "", &sherpa.Error{Code: "user:error", Message: "single sign-on failed: " + err.Error()}
+	} else if err != nil || !valid {
+		log.Infox("single sign-on login failed", err, slog.String("email", email))
+		time.Sleep(BadAuthDelay)
+		authResult = "badcreds"
+		return "", &sherpa.Error{Code: "user:loginFailed", Message: "single sign-on login not accepted"}
+	}
+	authResult = "ok"
+	mox.LimiterFailedAuth.Reset(ip, start)
+	log.Info("single sign-on login", slog.String("account", accountName), slog.String("email", email), slog.String("kind", kind))
+
+	return loginSession(ctx, log, sessionAuth, kind, cookiePath, isForwarded, w, r, accountName, loginAddress)
+}
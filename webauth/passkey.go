@@ -0,0 +1,251 @@
+package webauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mjl-/sherpa"
+
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/store"
+	"github.com/mjl-/mox/webauthn"
+)
+
+// PasskeyCreationOptions are the parameters for registering a passkey with
+// navigator.credentials.create in the browser. Binary values are base64url
+// encoded.
+type PasskeyCreationOptions struct {
+	Challenge            string
+	RPID                 string
+	RPName               string
+	UserID               string
+	UserName             string
+	UserDisplayName      string
+	ExcludeCredentialIDs []string // Already registered, to prevent registering an authenticator twice.
+	Algorithms           []int    // COSE algorithm identifiers.
+	Timeout              int      // In milliseconds.
+}
+
+// PasskeyRequestOptions are the parameters for logging in with a passkey with
+// navigator.credentials.get in the browser.
+type PasskeyRequestOptions struct {
+	Challenge string
+	RPID      string
+	Timeout   int // In milliseconds.
+}
+
+// PasskeyAttestation is the response from navigator.credentials.create, with
+// base64url encoded values.
+type PasskeyAttestation struct {
+	ClientDataJSON    string
+	AttestationObject string
+}
+
+// PasskeyAssertion is the response from navigator.credentials.get, with
+// base64url encoded values.
+type PasskeyAssertion struct {
+	CredentialID      string
+	ClientDataJSON    string
+	AuthenticatorData string
+	Signature         string
+	UserHandle        string // User ID from registration, identifying the account.
+}
+
+// Time for completing a registration or login with a passkey.
+const passkeyTimeout = 5 * time.Minute
+
+// passkeyChallenge is a pending registration or login.
+type passkeyChallenge struct {
+	kind        string // webadmin, webaccount, webmail.
+	register    bool   // Registration instead of login.
+	accountName string // For registration only.
+	rpID        string
+	origin      string
+	expires     time.Time
+}
+
+// Pending challenges, keyed by base64url encoded challenge. Challenges are
+// removed when used.
+var passkeyChallenges = struct {
+	sync.Mutex
+	m map[string]passkeyChallenge
+}{m: map[string]passkeyChallenge{}}
+
+// passkeyRP returns the relying party ID (host name) and origin for a request.
+func passkeyRP(isForwarded bool, r *http.Request) (rpID, origin string) {
+	rpID = r.Host
+	if host, _, err := net.SplitHostPort(r.Host); err == nil {
+		rpID = host
+	}
+	rpID = strings.ToLower(strings.TrimSuffix(strings.TrimPrefix(rpID, "["), "]"))
+	scheme := "http"
+	if isHTTPS(isForwarded, r) {
+		scheme = "https"
+	}
+	return rpID, scheme + "://" + strings.ToLower(r.Host)
+}
+
+// passkeyChallengeAdd registers a new pending challenge and returns it.
+func passkeyChallengeAdd(c passkeyChallenge) []byte {
+	passkeyChallenges.Lock()
+	defer passkeyChallenges.Unlock()
+
+	now := time.Now()
+	for k, pc := range passkeyChallenges.m {
+		if now.After(pc.expires) {
+			delete(passkeyChallenges.m, k)
+		}
+	}
+	// Prevent unbounded growth from unfinished logins.
+	if len(passkeyChallenges.m) >= 1000 {
+		for k := range passkeyChallenges.m {
+			delete(passkeyChallenges.m, k)
+			break
+		}
+	}
+
+	challenge := webauthn.NewChallenge()
+	c.expires = now.Add(passkeyTimeout)
+	passkeyChallenges.m[webauthn.Base64.EncodeToString(challenge)] = c
+	return challenge
+}
+
+// passkeyChallengeTake removes and returns the pending challenge from the client
+// data of a response, if it matches kind, register and accountName.
+func passkeyChallengeTake(kind string, register bool, accountName string, clientDataJSON []byte) (passkeyChallenge, []byte, error) {
+	challenge, err := webauthn.ClientDataChallenge(clientDataJSON)
+	if err != nil {
+		return passkeyChallenge{}, nil, err
+	}
+
+	passkeyChallenges.Lock()
+	defer passkeyChallenges.Unlock()
+	k := webauthn.Base64.EncodeToString(challenge)
+	c, ok := passkeyChallenges.m[k]
+	if !ok || c.kind != kind || c.register != register || c.accountName != accountName || time.Now().After(c.expires) {
+		return passkeyChallenge{}, nil, errors.New("unknown or expired challenge")
+	}
+	delete(passkeyChallenges.m, k)
+	return c, challenge, nil
+}
+
+// PasskeyRegisterPrep starts registration of a passkey for an authenticated
+// session, returning the options for the browser. For accounts, accountName is
+// the account, for admin it is empty. userID is returned by the authenticator
+// as user handle when logging in, userName is shown to the user by the
+// authenticator.
+func PasskeyRegisterPrep(kind string, isForwarded bool, r *http.Request, accountName, userID, userName string, excludeCredentialIDs []string) PasskeyCreationOptions {
+	rpID, origin := passkeyRP(isForwarded, r)
+	challenge := passkeyChallengeAdd(passkeyChallenge{kind: kind, register: true, accountName: accountName, rpID: rpID, origin: origin})
+	return PasskeyCreationOptions{
+		Challenge:            webauthn.Base64.EncodeToString(challenge),
+		RPID:                 rpID,
+		RPName:               "mox " + mox.Conf.Static.HostnameDomain.Name(),
+		UserID:               webauthn.Base64.EncodeToString([]byte(userID)),
+		UserName:             userName,
+		UserDisplayName:      userName,
+		ExcludeCredentialIDs: excludeCredentialIDs,
+		Algorithms:           webauthn.Algorithms,
+		Timeout:              int(passkeyTimeout / time.Millisecond),
+	}
+}
+
+// PasskeyRegister verifies the response for a registration started with
+// PasskeyRegisterPrep, returning the new credential.
+func PasskeyRegister(kind, accountName string, response PasskeyAttestation) (webauthn.Credential, error) {
+	clientDataJSON, err := webauthn.Base64.DecodeString(response.ClientDataJSON)
+	if err != nil {
+		return webauthn.Credential{}, fmt.Errorf("decoding client data: %v", err)
+	}
+	attestationObject, err := webauthn.Base64.DecodeString(response.AttestationObject)
+	if err != nil {
+		return webauthn.Credential{}, fmt.Errorf("decoding attestation object: %v", err)
+	}
+	c, challenge, err := passkeyChallengeTake(kind, true, accountName, clientDataJSON)
+	if err != nil {
+		return webauthn.Credential{}, err
+	}
+	return webauthn.VerifyRegistration(c.rpID, c.origin, challenge, clientDataJSON, attestationObject)
+}
+
+// PasskeyLoginPrep starts a login with a passkey, returning the options for the
+// browser. The loginToken for PasskeyLogin should be requested with LoginPrep
+// after the user has selected a passkey, the login token cookie is short-lived.
+func PasskeyLoginPrep(kind string, isForwarded bool, r *http.Request) PasskeyRequestOptions {
+	rpID, origin := passkeyRP(isForwarded, r)
+	challenge := passkeyChallengeAdd(passkeyChallenge{kind: kind, rpID: rpID, origin: origin})
+	return PasskeyRequestOptions{
+		Challenge: webauthn.Base64.EncodeToString(challenge),
+		RPID:      rpID,
+		Timeout:   int(passkeyTimeout / time.Millisecond),
+	}
+}
+
+// PasskeyLogin handles a login with a passkey, started with PasskeyLoginPrep.
+// Like Login, a session cookie is set and the CSRF token returned. Since
+// passkeys require user verification by the authenticator, no two-factor
+// authentication code is required.
+func PasskeyLogin(ctx context.Context, log mlog.Log, sessionAuth SessionAuth, kind, cookiePath string, isForwarded bool, w http.ResponseWriter, r *http.Request, loginToken string, response PasskeyAssertion) (store.CSRFToken, error) {
+	ip, start, err := loginCheck(log, kind, isForwarded, r, loginToken)
+	if err != nil {
+		return "", err
+	}
+
+	var authResult string
+	defer func() {
+		metrics.AuthenticationInc(kind, "passkey", authResult)
+	}()
+
+	var valid bool
+	var accountName, loginAddress string
+	clientDataJSON, err := webauthn.Base64.DecodeString(response.ClientDataJSON)
+	if err == nil {
+		var c passkeyChallenge
+		var challenge []byte
+		c, challenge, err = passkeyChallengeTake(kind, false, "", clientDataJSON)
+		if err == nil {
+			valid, accountName, loginAddress, err = sessionAuth.passkeyLogin(ctx, log, kind, c.rpID, c.origin, challenge, response)
+		}
+	}
+	var serr *sherpa.Error
+	if err != nil && errors.As(err, &serr) {
+		authResult = "error"
+		return "", serr
+	} else if err != nil || !valid {
+		log.Debugx("passkey login failed", err)
+		time.Sleep(BadAuthDelay)
+		authResult = "badcreds"
+		return "", &sherpa.Error{Code: "user:loginFailed", Message: "invalid passkey"}
+	}
+	authResult = "ok"
+	mox.LimiterFailedAuth.Reset(ip, start)
+	log.Info("passkey login", slog.String("account", accountName), slog.String("kind", kind))
+
+	return loginSession(ctx, log, sessionAuth, kind, cookiePath, isForwarded, w, r, accountName, loginAddress)
+}
+
+// passkeyAssertionVerify decodes and verifies an assertion for a credential.
+func passkeyAssertionVerify(rpID, origin string, challenge []byte, cred webauthn.Credential, response PasskeyAssertion) (uint32, error) {
+	clientDataJSON, err := webauthn.Base64.DecodeString(response.ClientDataJSON)
+	if err != nil {
+		return 0, fmt.Errorf("decoding client data: %v", err)
+	}
+	authData, err := webauthn.Base64.DecodeString(response.AuthenticatorData)
+	if err != nil {
+		return 0, fmt.Errorf("decoding authenticator data: %v", err)
+	}
+	sig, err := webauthn.Base64.DecodeString(response.Signature)
+	if err != nil {
+		return 0, fmt.Errorf("decoding signature: %v", err)
+	}
+	return webauthn.VerifyAssertion(rpID, origin, challenge, cred, clientDataJSON, authData, sig)
+}
@@ -18,6 +18,12 @@ along with login credentials. If the loginToken is missing, the login attempt
 fails before checking any credentials. This should prevent third party websites
 from tricking a browser into logging in.
 
+Instead of a password, a passkey (WebAuthn credential) can be used to login,
+with PasskeyLoginPrep and PasskeyLogin. Passkeys are registered for an
+authenticated session with PasskeyRegisterPrep and PasskeyRegister. Passkeys
+for accounts are stored in the account database, passkeys for the admin in a
+file next to the admin password file.
+
 Sessions are stored server-side, and their lifetime automatically extended each
 time they are used. This makes it easy to invalidate existing sessions after a
 password change, and keeps the frontend free from handling long-term vs
@@ -25,18 +31,26 @@ short-term sessions.
 
 Sessions for the admin interface have a lifetime of 12 hours after last use,
 are only stored in memory (don't survive a server restart), and only 10
-sessions can exist at a time (the oldest session is dropped).
+sessions can exist at a time per login (the oldest session is dropped). Domain
+admins log in to the admin interface with the credentials of their account,
+their sessions are for a single login address.
 
 Sessions for the account and mail interfaces have a lifetime of 24 hours after
 last use, are kept in memory and stored in the database (do survive a server
 restart), and only 100 sessions can exist per account (the oldest session is
 dropped).
+
+Other accounts can be linked to an account with LinkAccount, after verifying
+their credentials. Sessions of the account can then switch to a linked account
+with SetActiveAccount, which sets a cookie that ActiveAccount evaluates for
+each request.
 */
 package webauth
 
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net"
@@ -56,10 +70,26 @@ import (
 // Delay before responding in case of bad authentication attempt.
 var BadAuthDelay = time.Second
 
+// errTOTPRequired is returned by SessionAuth.login when the credentials are
+// valid, but a two-factor authentication code is required and was not specified.
+var errTOTPRequired = errors.New("two-factor authentication code required")
+
 // SessionAuth handles login and session storage, used for both account and
 // admin authentication.
 type SessionAuth interface {
-	login(ctx context.Context, log mlog.Log, username, password string) (valid bool, accountName string, rerr error)
+	// Verify credentials. If two-factor authentication is enabled and totpCode is
+	// empty, errTOTPRequired must be returned. A *sherpa.Error is passed on to the
+	// client. Kind is webadmin, webaccount or webmail.
+	login(ctx context.Context, log mlog.Log, kind, username, password, totpCode string) (valid bool, accountName string, rerr error)
+
+	// Verify a passkey assertion for a login with challenge, for relying party
+	// rpID and origin. The login address is used for the new session. A
+	// *sherpa.Error is passed on to the client.
+	passkeyLogin(ctx context.Context, log mlog.Log, kind, rpID, origin string, challenge []byte, response PasskeyAssertion) (valid bool, accountName, loginAddress string, rerr error)
+
+	// Verify a login through the OpenID Connect identity provider, for the
+	// verified email address from the ID token.
+	oidcLogin(ctx context.Context, log mlog.Log, kind, email string) (valid bool, accountName, loginAddress string, rerr error)
 
 	// Add a new session for account and login address.
 	add(ctx context.Context, log mlog.Log, accountName string, loginAddress string) (sessionToken store.SessionToken, csrfToken store.CSRFToken, rerr error)
@@ -223,36 +253,37 @@ func LoginPrep(ctx context.Context, log mlog.Log, kind, cookiePath string, isFor
 // response and returning the associated CSRF token.
 //
 // In case of a user error, a *sherpa.Error is returned that sherpa handlers can
-// pass to panic. For bad credentials, the error code is "user:loginFailed".
-func Login(ctx context.Context, log mlog.Log, sessionAuth SessionAuth, kind, cookiePath string, isForwarded bool, w http.ResponseWriter, r *http.Request, loginToken, username, password string) (store.CSRFToken, error) {
-	loginCookie, _ := r.Cookie(kind + "login")
-	if loginCookie == nil || loginCookie.Value != loginToken {
-		msg := "missing login token cookie"
-		if isForwarded && loginCookie == nil {
-			msg += " (hint: reverse proxy must keep path, for login cookie)"
-		}
-		return "", &sherpa.Error{Code: "user:error", Message: msg}
-	}
-
-	ip := RemoteIP(log, isForwarded, r)
-	if ip == nil {
-		return "", fmt.Errorf("cannot find ip for rate limit check (missing x-forwarded-for header?)")
-	}
-	start := time.Now()
-	if !mox.LimiterFailedAuth.Add(ip, start, 1) {
-		metrics.AuthenticationRatelimitedInc(kind)
-		return "", &sherpa.Error{Code: "user:error", Message: "too many authentication attempts"}
+// pass to panic. For bad credentials, the error code is "user:loginFailed". If
+// the credentials are valid but two-factor authentication is enabled and totpCode
+// is empty, the error code is "user:totpRequired", and the login should be
+// retried with a code.
+func Login(ctx context.Context, log mlog.Log, sessionAuth SessionAuth, kind, cookiePath string, isForwarded bool, w http.ResponseWriter, r *http.Request, loginToken, username, password, totpCode string) (store.CSRFToken, error) {
+	ip, start, err := loginCheck(log, kind, isForwarded, r, loginToken)
+	if err != nil {
+		return "", err
 	}
 
-	valid, accountName, err := sessionAuth.login(ctx, log, username, password)
+	valid, accountName, err := sessionAuth.login(ctx, log, kind, username, password, totpCode)
 	var authResult string
 	defer func() {
 		metrics.AuthenticationInc(kind, "weblogin", authResult)
 	}()
-	if err != nil {
+	var serr *sherpa.Error
+	if err != nil && errors.Is(err, errTOTPRequired) {
+		// Treated as a failed attempt, so the response can't be used to verify password
+		// guesses any faster than with failed logins.
+		mox.AutoBanFailure(log, ip, mox.AutoBanAuth)
+		time.Sleep(BadAuthDelay)
+		authResult = "totprequired"
+		return "", &sherpa.Error{Code: "user:totpRequired", Message: err.Error()}
+	} else if err != nil && errors.As(err, &serr) {
+		authResult = "error"
+		return "", serr
+	} else if err != nil {
 		authResult = "error"
 		return "", fmt.Errorf("evaluating login attempt: %v", err)
 	} else if !valid {
+		mox.AutoBanFailure(log, ip, mox.AutoBanAuth)
 		time.Sleep(BadAuthDelay)
 		authResult = "badcreds"
 		return "", &sherpa.Error{Code: "user:loginFailed", Message: "invalid credentials"}
@@ -260,7 +291,38 @@ func Login(ctx context.Context, log mlog.Log, sessionAuth SessionAuth, kind, coo
 	authResult = "ok"
 	mox.LimiterFailedAuth.Reset(ip, start)
 
-	sessionToken, csrfToken, err := sessionAuth.add(ctx, log, accountName, username)
+	return loginSession(ctx, log, sessionAuth, kind, cookiePath, isForwarded, w, r, accountName, username)
+}
+
+// loginCheck verifies the login token cookie matches loginToken, and checks the
+// rate limiter for failed authentication attempts. The remote IP and start time
+// are returned for resetting the rate limiter after a successful login.
+func loginCheck(log mlog.Log, kind string, isForwarded bool, r *http.Request, loginToken string) (net.IP, time.Time, error) {
+	loginCookie, _ := r.Cookie(kind + "login")
+	if loginCookie == nil || loginCookie.Value != loginToken {
+		msg := "missing login token cookie"
+		if isForwarded && loginCookie == nil {
+			msg += " (hint: reverse proxy must keep path, for login cookie)"
+		}
+		return nil, time.Time{}, &sherpa.Error{Code: "user:error", Message: msg}
+	}
+
+	ip := RemoteIP(log, isForwarded, r)
+	if ip == nil {
+		return nil, time.Time{}, fmt.Errorf("cannot find ip for rate limit check (missing x-forwarded-for header?)")
+	}
+	start := time.Now()
+	if mox.AutoBanned(ip) || !mox.LimiterFailedAuth.Add(ip, start, 1) {
+		metrics.AuthenticationRatelimitedInc(kind)
+		return nil, time.Time{}, &sherpa.Error{Code: "user:error", Message: "too many authentication attempts"}
+	}
+	return ip, start, nil
+}
+
+// loginSession adds a session after a successful login, sets the session cookie
+// and removes the login cookie.
+func loginSession(ctx context.Context, log mlog.Log, sessionAuth SessionAuth, kind, cookiePath string, isForwarded bool, w http.ResponseWriter, r *http.Request, accountName, loginAddress string) (store.CSRFToken, error) {
+	sessionToken, csrfToken, err := sessionAuth.add(ctx, log, accountName, loginAddress)
 	if err != nil {
 		log.Errorx("adding session after login", err)
 		return "", fmt.Errorf("adding session: %v", err)
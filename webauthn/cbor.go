@@ -0,0 +1,136 @@
+package webauthn
+
+import (
+	"errors"
+	"fmt"
+)
+
+var errCBOR = errors.New("malformed cbor")
+
+// cborDecoder decodes the subset of CBOR (RFC 8949) used in WebAuthn
+// attestation objects, authenticator data and COSE keys: integers, byte and text
+// strings, arrays, maps, and simple values. Indefinite lengths, tags and floats
+// are not supported.
+type cborDecoder struct {
+	buf []byte
+	o   int // Offset in buf.
+}
+
+// Decoded values are int64, []byte, string, []any, map[any]any, bool or nil.
+func (d *cborDecoder) value(depth int) (any, error) {
+	if depth > 16 {
+		return nil, fmt.Errorf("%w: nested too deeply", errCBOR)
+	}
+	major, arg, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case 0:
+		if arg > 1<<63-1 {
+			return nil, fmt.Errorf("%w: integer too large", errCBOR)
+		}
+		return int64(arg), nil
+	case 1:
+		if arg > 1<<63-1 {
+			return nil, fmt.Errorf("%w: integer too large", errCBOR)
+		}
+		return -1 - int64(arg), nil
+	case 2, 3:
+		if arg > uint64(len(d.buf)-d.o) {
+			return nil, fmt.Errorf("%w: string beyond end", errCBOR)
+		}
+		buf := d.buf[d.o : d.o+int(arg)]
+		d.o += int(arg)
+		if major == 3 {
+			return string(buf), nil
+		}
+		return buf, nil
+	case 4:
+		if arg > uint64(len(d.buf)-d.o) {
+			return nil, fmt.Errorf("%w: array beyond end", errCBOR)
+		}
+		l := make([]any, arg)
+		for i := range l {
+			if l[i], err = d.value(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return l, nil
+	case 5:
+		if arg > uint64(len(d.buf)-d.o) {
+			return nil, fmt.Errorf("%w: map beyond end", errCBOR)
+		}
+		m := map[any]any{}
+		for i := uint64(0); i < arg; i++ {
+			k, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, fmt.Errorf("%w: unsupported map key type %T", errCBOR, k)
+			}
+			v, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = v
+		}
+		return m, nil
+	case 7:
+		switch arg {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22, 23:
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%w: unsupported simple value %d", errCBOR, arg)
+	}
+	return nil, fmt.Errorf("%w: unsupported major type %d", errCBOR, major)
+}
+
+// head reads the initial byte and argument of a data item.
+func (d *cborDecoder) head() (major byte, arg uint64, rerr error) {
+	if d.o >= len(d.buf) {
+		return 0, 0, fmt.Errorf("%w: unexpected end", errCBOR)
+	}
+	b := d.buf[d.o]
+	d.o++
+	major = b >> 5
+	info := b & 0x1f
+	var n int
+	switch {
+	case info < 24:
+		return major, uint64(info), nil
+	case info == 24:
+		n = 1
+	case info == 25:
+		n = 2
+	case info == 26:
+		n = 4
+	case info == 27:
+		n = 8
+	default:
+		return 0, 0, fmt.Errorf("%w: unsupported additional information %d", errCBOR, info)
+	}
+	if d.o+n > len(d.buf) {
+		return 0, 0, fmt.Errorf("%w: unexpected end", errCBOR)
+	}
+	for _, c := range d.buf[d.o : d.o+n] {
+		arg = arg<<8 | uint64(c)
+	}
+	d.o += n
+	return major, arg, nil
+}
+
+// cborDecode decodes a single value from buf, returning the number of bytes
+// consumed.
+func cborDecode(buf []byte) (v any, n int, rerr error) {
+	d := cborDecoder{buf: buf}
+	v, err := d.value(0)
+	return v, d.o, err
+}
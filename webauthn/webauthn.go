@@ -0,0 +1,317 @@
+// Package webauthn implements the server-side (relying party) verification of
+// WebAuthn registrations and assertions, for logging in with passkeys.
+//
+// Only what is needed for passkey logins is implemented. Attestation statements
+// are not verified: registrations request "none" attestation, the authenticator
+// is not required to prove its make/model. Supported public key algorithms are
+// ES256, EdDSA (Ed25519) and RS256. User verification (e.g. PIN or biometrics on
+// the authenticator) is required for registration and login, making a passkey
+// login a two-factor login by itself.
+package webauthn
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// Errors returned when verifying registrations and assertions.
+var (
+	ErrClientData    = errors.New("invalid client data")
+	ErrAuthData      = errors.New("invalid authenticator data")
+	ErrPublicKey     = errors.New("invalid or unsupported public key")
+	ErrSignature     = errors.New("invalid signature")
+	ErrSignCount     = errors.New("signature counter did not increase, authenticator may be cloned")
+	ErrNotVerified   = errors.New("user not verified by authenticator")
+	ErrNotPresent    = errors.New("user not present")
+	ErrAttestation   = errors.New("invalid attestation object")
+	ErrNoCredentials = errors.New("no attested credential data")
+)
+
+// COSE algorithm identifiers, RFC 9053.
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+// Algorithms are the supported public key algorithms, in order of preference, to
+// be requested during registration.
+var Algorithms = []int{AlgES256, AlgEdDSA, AlgRS256}
+
+// Flags in authenticator data.
+const (
+	flagUserPresent   = 0x01
+	flagUserVerified  = 0x04
+	flagAttestedCreds = 0x40
+)
+
+// Base64 is the encoding for binary WebAuthn values exchanged with the browser,
+// as used in the JSON serialization of WebAuthn responses.
+var Base64 = base64.RawURLEncoding
+
+// NewChallenge returns a new random challenge for a registration or
+// authentication ceremony.
+func NewChallenge() []byte {
+	buf := make([]byte, 32)
+	if _, err := cryptorand.Read(buf); err != nil {
+		panic(fmt.Sprintf("reading random bytes: %v", err))
+	}
+	return buf
+}
+
+// Credential is a registered public key credential.
+type Credential struct {
+	ID        []byte // Credential ID chosen by the authenticator.
+	PublicKey []byte // COSE encoded public key.
+	SignCount uint32
+}
+
+// clientData is the JSON-serialized client data, signed by the authenticator.
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// ClientDataChallenge returns the challenge from clientDataJSON. It can be used
+// to look up the pending ceremony. The caller must still verify the response.
+func ClientDataChallenge(clientDataJSON []byte) ([]byte, error) {
+	var cd clientData
+	if err := json.Unmarshal(clientDataJSON, &cd); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrClientData, err)
+	}
+	buf, err := Base64.DecodeString(cd.Challenge)
+	if err != nil {
+		return nil, fmt.Errorf("%w: decoding challenge: %v", ErrClientData, err)
+	}
+	return buf, nil
+}
+
+func checkClientData(clientDataJSON []byte, typ string, challenge []byte, origin string) error {
+	var cd clientData
+	if err := json.Unmarshal(clientDataJSON, &cd); err != nil {
+		return fmt.Errorf("%w: %v", ErrClientData, err)
+	}
+	if cd.Type != typ {
+		return fmt.Errorf("%w: got type %q, expected %q", ErrClientData, cd.Type, typ)
+	}
+	if cd.Challenge != Base64.EncodeToString(challenge) {
+		return fmt.Errorf("%w: challenge mismatch", ErrClientData)
+	}
+	if cd.Origin != origin {
+		return fmt.Errorf("%w: got origin %q, expected %q", ErrClientData, cd.Origin, origin)
+	}
+	if cd.CrossOrigin {
+		return fmt.Errorf("%w: cross-origin request", ErrClientData)
+	}
+	return nil
+}
+
+// authData is parsed authenticator data.
+type authData struct {
+	RPIDHash  []byte
+	Flags     byte
+	SignCount uint32
+
+	// Attested credential data, only during registration.
+	CredentialID []byte
+	PublicKey    []byte
+}
+
+func parseAuthData(buf []byte, rpID string) (authData, error) {
+	var ad authData
+	if len(buf) < 37 {
+		return ad, fmt.Errorf("%w: too short", ErrAuthData)
+	}
+	ad.RPIDHash = buf[:32]
+	ad.Flags = buf[32]
+	ad.SignCount = binary.BigEndian.Uint32(buf[33:37])
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	if !bytes.Equal(ad.RPIDHash, rpIDHash[:]) {
+		return ad, fmt.Errorf("%w: relying party id mismatch", ErrAuthData)
+	}
+	if ad.Flags&flagUserPresent == 0 {
+		return ad, ErrNotPresent
+	}
+	if ad.Flags&flagUserVerified == 0 {
+		return ad, ErrNotVerified
+	}
+	if ad.Flags&flagAttestedCreds == 0 {
+		return ad, nil
+	}
+
+	// Attested credential data: aaguid (16), credential id length (2), credential
+	// id, COSE public key.
+	o := 37 + 16
+	if len(buf) < o+2 {
+		return ad, fmt.Errorf("%w: attested credential data too short", ErrAuthData)
+	}
+	n := int(binary.BigEndian.Uint16(buf[o : o+2]))
+	o += 2
+	if n == 0 || n > 1023 || len(buf) < o+n {
+		return ad, fmt.Errorf("%w: bad credential id length", ErrAuthData)
+	}
+	ad.CredentialID = buf[o : o+n]
+	o += n
+	_, keyLen, err := cborDecode(buf[o:])
+	if err != nil {
+		return ad, fmt.Errorf("%w: public key: %v", ErrAuthData, err)
+	}
+	ad.PublicKey = buf[o : o+keyLen]
+	// Extensions may follow, we don't use them.
+	return ad, nil
+}
+
+// VerifyRegistration verifies the response of a registration ceremony
+// (navigator.credentials.create), for relying party rpID (the domain name) and
+// origin (e.g. "https://mail.example.org"), for the challenge sent to the
+// browser. The new credential is returned.
+func VerifyRegistration(rpID, origin string, challenge, clientDataJSON, attestationObject []byte) (Credential, error) {
+	if err := checkClientData(clientDataJSON, "webauthn.create", challenge, origin); err != nil {
+		return Credential{}, err
+	}
+	v, _, err := cborDecode(attestationObject)
+	if err != nil {
+		return Credential{}, fmt.Errorf("%w: %v", ErrAttestation, err)
+	}
+	m, ok := v.(map[any]any)
+	if !ok {
+		return Credential{}, fmt.Errorf("%w: not a map", ErrAttestation)
+	}
+	buf, ok := m["authData"].([]byte)
+	if !ok {
+		return Credential{}, fmt.Errorf("%w: missing authData", ErrAttestation)
+	}
+	ad, err := parseAuthData(buf, rpID)
+	if err != nil {
+		return Credential{}, err
+	}
+	if ad.CredentialID == nil {
+		return Credential{}, ErrNoCredentials
+	}
+	if _, err := parsePublicKey(ad.PublicKey); err != nil {
+		return Credential{}, err
+	}
+	c := Credential{
+		ID:        append([]byte{}, ad.CredentialID...),
+		PublicKey: append([]byte{}, ad.PublicKey...),
+		SignCount: ad.SignCount,
+	}
+	return c, nil
+}
+
+// VerifyAssertion verifies the response of an authentication ceremony
+// (navigator.credentials.get) for a registered credential. The new signature
+// counter is returned, to be stored with the credential.
+func VerifyAssertion(rpID, origin string, challenge []byte, cred Credential, clientDataJSON, authenticatorData, signature []byte) (signCount uint32, rerr error) {
+	if err := checkClientData(clientDataJSON, "webauthn.get", challenge, origin); err != nil {
+		return 0, err
+	}
+	ad, err := parseAuthData(authenticatorData, rpID)
+	if err != nil {
+		return 0, err
+	}
+	pk, err := parsePublicKey(cred.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+	cdh := sha256.Sum256(clientDataJSON)
+	msg := append(append([]byte{}, authenticatorData...), cdh[:]...)
+	if err := pk.verify(msg, signature); err != nil {
+		return 0, err
+	}
+	// Authenticators that don't implement counters (e.g. synced passkeys) always
+	// send 0.
+	if (ad.SignCount != 0 || cred.SignCount != 0) && ad.SignCount <= cred.SignCount {
+		return 0, ErrSignCount
+	}
+	return ad.SignCount, nil
+}
+
+type publicKey struct {
+	alg int64
+	key crypto.PublicKey
+}
+
+// parsePublicKey parses a COSE key, RFC 9052 section 7, with parameters from RFC
+// 9053.
+func parsePublicKey(buf []byte) (publicKey, error) {
+	v, _, err := cborDecode(buf)
+	if err != nil {
+		return publicKey{}, fmt.Errorf("%w: %v", ErrPublicKey, err)
+	}
+	m, ok := v.(map[any]any)
+	if !ok {
+		return publicKey{}, fmt.Errorf("%w: not a map", ErrPublicKey)
+	}
+	kty, _ := m[int64(1)].(int64)
+	alg, _ := m[int64(3)].(int64)
+	switch {
+	case kty == 2 && alg == AlgES256:
+		crv, _ := m[int64(-1)].(int64)
+		x, _ := m[int64(-2)].([]byte)
+		y, _ := m[int64(-3)].([]byte)
+		if crv != 1 || len(x) != 32 || len(y) != 32 {
+			return publicKey{}, fmt.Errorf("%w: bad ec2 key parameters", ErrPublicKey)
+		}
+		pk := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pk.Curve.IsOnCurve(pk.X, pk.Y) {
+			return publicKey{}, fmt.Errorf("%w: point not on curve", ErrPublicKey)
+		}
+		return publicKey{alg, pk}, nil
+	case kty == 1 && alg == AlgEdDSA:
+		crv, _ := m[int64(-1)].(int64)
+		x, _ := m[int64(-2)].([]byte)
+		if crv != 6 || len(x) != ed25519.PublicKeySize {
+			return publicKey{}, fmt.Errorf("%w: bad okp key parameters", ErrPublicKey)
+		}
+		return publicKey{alg, ed25519.PublicKey(x)}, nil
+	case kty == 3 && alg == AlgRS256:
+		n, _ := m[int64(-1)].([]byte)
+		e, _ := m[int64(-2)].([]byte)
+		if len(n) < 2048/8 || len(e) == 0 || len(e) > 4 {
+			return publicKey{}, fmt.Errorf("%w: bad rsa key parameters", ErrPublicKey)
+		}
+		var exp int
+		for _, c := range e {
+			exp = exp<<8 | int(c)
+		}
+		return publicKey{alg, &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exp}}, nil
+	}
+	return publicKey{}, fmt.Errorf("%w: key type %d with algorithm %d", ErrPublicKey, kty, alg)
+}
+
+func (pk publicKey) verify(msg, sig []byte) error {
+	switch k := pk.key.(type) {
+	case *ecdsa.PublicKey:
+		h := sha256.Sum256(msg)
+		if !ecdsa.VerifyASN1(k, h[:], sig) {
+			return ErrSignature
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(k, msg, sig) {
+			return ErrSignature
+		}
+	case *rsa.PublicKey:
+		h := sha256.Sum256(msg)
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, h[:], sig); err != nil {
+			return ErrSignature
+		}
+	default:
+		return ErrPublicKey
+	}
+	return nil
+}
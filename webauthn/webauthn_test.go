@@ -0,0 +1,242 @@
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+)
+
+// cborEncode encodes the types returned by the decoder, for constructing test
+// data.
+func cborEncode(v any) []byte {
+	head := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n < 1<<8:
+			return []byte{major<<5 | 24, byte(n)}
+		case n < 1<<16:
+			return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+		case n < 1<<32:
+			return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+		}
+		return binary.BigEndian.AppendUint64([]byte{major<<5 | 27}, n)
+	}
+	switch x := v.(type) {
+	case int:
+		return cborEncode(int64(x))
+	case int64:
+		if x >= 0 {
+			return head(0, uint64(x))
+		}
+		return head(1, uint64(-1-x))
+	case []byte:
+		return append(head(2, uint64(len(x))), x...)
+	case string:
+		return append(head(3, uint64(len(x))), x...)
+	case []any:
+		buf := head(4, uint64(len(x)))
+		for _, e := range x {
+			buf = append(buf, cborEncode(e)...)
+		}
+		return buf
+	case map[any]any:
+		var keys [][]byte
+		for k := range x {
+			keys = append(keys, append(cborEncode(k), cborEncode(x[k])...))
+		}
+		sort.Slice(keys, func(i, j int) bool { return string(keys[i]) < string(keys[j]) })
+		buf := head(5, uint64(len(x)))
+		for _, kv := range keys {
+			buf = append(buf, kv...)
+		}
+		return buf
+	case bool:
+		if x {
+			return []byte{0xf5}
+		}
+		return []byte{0xf4}
+	case nil:
+		return []byte{0xf6}
+	}
+	panic(fmt.Sprintf("unsupported type %T", v))
+}
+
+func tcheck(t *testing.T, err error, msg string) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %s", msg, err)
+	}
+}
+
+func TestCBOR(t *testing.T) {
+	v := map[any]any{
+		int64(1):  int64(2),
+		int64(-1): int64(-300),
+		"s":       "text",
+		"b":       []byte{1, 2, 3},
+		"l":       []any{true, false, nil, int64(1 << 40)},
+	}
+	buf := cborEncode(v)
+	d, n, err := cborDecode(append(buf, 0xff))
+	tcheck(t, err, "decode")
+	if n != len(buf) {
+		t.Fatalf("decoded %d bytes, expected %d", n, len(buf))
+	}
+	if fmt.Sprint(d) != fmt.Sprint(v) {
+		t.Fatalf("got %v, expected %v", d, v)
+	}
+
+	// Truncated and unsupported input.
+	for _, buf := range [][]byte{buf[:len(buf)-1], {0x5f}, {0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, {0xa1, 0x40, 0x01}, {0xf9, 0, 0}} {
+		if _, _, err := cborDecode(buf); !errors.Is(err, errCBOR) {
+			t.Fatalf("decoding %x: got err %v, expected errCBOR", buf, err)
+		}
+	}
+}
+
+type authenticator struct {
+	credID    []byte
+	cosekey   []byte
+	sign      func(msg []byte) []byte
+	signCount uint32
+}
+
+func newES256(t *testing.T) *authenticator {
+	k, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	tcheck(t, err, "generate key")
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	k.X.FillBytes(x)
+	k.Y.FillBytes(y)
+	return &authenticator{
+		credID:  []byte("es256-credential"),
+		cosekey: cborEncode(map[any]any{int64(1): int64(2), int64(3): int64(AlgES256), int64(-1): int64(1), int64(-2): x, int64(-3): y}),
+		sign: func(msg []byte) []byte {
+			h := sha256.Sum256(msg)
+			sig, err := ecdsa.SignASN1(cryptorand.Reader, k, h[:])
+			tcheck(t, err, "sign")
+			return sig
+		},
+	}
+}
+
+func newEdDSA(t *testing.T) *authenticator {
+	pub, priv, err := ed25519.GenerateKey(cryptorand.Reader)
+	tcheck(t, err, "generate key")
+	return &authenticator{
+		credID:  []byte("eddsa-credential"),
+		cosekey: cborEncode(map[any]any{int64(1): int64(1), int64(3): int64(AlgEdDSA), int64(-1): int64(6), int64(-2): []byte(pub)}),
+		sign: func(msg []byte) []byte {
+			return ed25519.Sign(priv, msg)
+		},
+	}
+}
+
+func (a *authenticator) authData(rpID string, flags byte, attested bool) []byte {
+	h := sha256.Sum256([]byte(rpID))
+	buf := append(h[:], flags)
+	buf = binary.BigEndian.AppendUint32(buf, a.signCount)
+	if attested {
+		buf = append(buf, make([]byte, 16)...) // aaguid
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(a.credID)))
+		buf = append(buf, a.credID...)
+		buf = append(buf, a.cosekey...)
+	}
+	return buf
+}
+
+func clientDataJSON(typ string, challenge []byte, origin string) []byte {
+	buf, err := json.Marshal(clientData{typ, Base64.EncodeToString(challenge), origin, false})
+	if err != nil {
+		panic(err)
+	}
+	return buf
+}
+
+func TestWebAuthn(t *testing.T) {
+	const rpID = "mail.mox.example"
+	const origin = "https://mail.mox.example"
+	const uvFlags = flagUserPresent | flagUserVerified
+
+	for _, a := range []*authenticator{newES256(t), newEdDSA(t)} {
+		challenge := NewChallenge()
+
+		// Authenticator data and client data are created for authRPID and clientOrigin.
+		register := func(authRPID, clientOrigin string, flags byte) (Credential, error) {
+			cd := clientDataJSON("webauthn.create", challenge, clientOrigin)
+			att := cborEncode(map[any]any{"fmt": "none", "attStmt": map[any]any{}, "authData": a.authData(authRPID, flags|flagAttestedCreds, true)})
+			return VerifyRegistration(rpID, origin, challenge, cd, att)
+		}
+
+		_, err := register("other.mox.example", origin, uvFlags)
+		if !errors.Is(err, ErrAuthData) {
+			t.Fatalf("registration for other rp id: got err %v, expected ErrAuthData", err)
+		}
+		_, err = register(rpID, "https://evil.example", uvFlags)
+		if !errors.Is(err, ErrClientData) {
+			t.Fatalf("registration for other origin: got err %v, expected ErrClientData", err)
+		}
+		_, err = register(rpID, origin, flagUserPresent)
+		if !errors.Is(err, ErrNotVerified) {
+			t.Fatalf("registration without user verification: got err %v, expected ErrNotVerified", err)
+		}
+
+		cred, err := register(rpID, origin, uvFlags)
+		tcheck(t, err, "registration")
+		if string(cred.ID) != string(a.credID) {
+			t.Fatalf("got credential id %q, expected %q", cred.ID, a.credID)
+		}
+
+		challenge = NewChallenge()
+		assert := func(challenge []byte, flags byte, modify func(ad, sig []byte)) (uint32, error) {
+			cd := clientDataJSON("webauthn.get", challenge, origin)
+			ad := a.authData(rpID, flags, false)
+			h := sha256.Sum256(cd)
+			sig := a.sign(append(append([]byte{}, ad...), h[:]...))
+			if modify != nil {
+				modify(ad, sig)
+			}
+			return VerifyAssertion(rpID, origin, challenge, cred, cd, ad, sig)
+		}
+
+		a.signCount = 1
+		n, err := assert(challenge, uvFlags, nil)
+		tcheck(t, err, "assertion")
+		if n != 1 {
+			t.Fatalf("got sign count %d, expected 1", n)
+		}
+		cred.SignCount = n
+
+		if _, err := assert(challenge, uvFlags, nil); !errors.Is(err, ErrSignCount) {
+			t.Fatalf("assertion with same sign count: got err %v, expected ErrSignCount", err)
+		}
+		a.signCount = 2
+		if _, err := assert(NewChallenge(), uvFlags, nil); err != nil {
+			t.Fatalf("assertion with new challenge: %v", err)
+		}
+		if _, err := VerifyAssertion(rpID, origin, challenge, cred, clientDataJSON("webauthn.get", NewChallenge(), origin), a.authData(rpID, uvFlags, false), nil); !errors.Is(err, ErrClientData) {
+			t.Fatalf("assertion for other challenge: got err %v, expected ErrClientData", err)
+		}
+		if _, err := assert(challenge, uvFlags, func(ad, sig []byte) { sig[len(sig)-1] ^= 1 }); !errors.Is(err, ErrSignature) {
+			t.Fatalf("assertion with bad signature: got err %v, expected ErrSignature", err)
+		}
+		if _, err := assert(challenge, flagUserPresent, nil); !errors.Is(err, ErrNotVerified) {
+			t.Fatalf("assertion without user verification: got err %v, expected ErrNotVerified", err)
+		}
+
+		// Authenticators without counter.
+		a.signCount = 0
+		cred.SignCount = 0
+		_, err = assert(challenge, uvFlags, nil)
+		tcheck(t, err, "assertion without counter")
+	}
+}
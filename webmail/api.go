@@ -33,9 +33,13 @@ import (
 	"github.com/mjl-/sherpadoc"
 	"github.com/mjl-/sherpaprom"
 
+	"github.com/mjl-/mox/autocrypt"
 	"github.com/mjl-/mox/config"
 	"github.com/mjl-/mox/dkim"
 	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/footer"
+	"github.com/mjl-/mox/i18n"
+	"github.com/mjl-/mox/ical"
 	"github.com/mjl-/mox/message"
 	"github.com/mjl-/mox/metrics"
 	"github.com/mjl-/mox/mlog"
@@ -45,11 +49,14 @@ import (
 	"github.com/mjl-/mox/mtasts"
 	"github.com/mjl-/mox/mtastsdb"
 	"github.com/mjl-/mox/queue"
+	"github.com/mjl-/mox/retention"
+	"github.com/mjl-/mox/sendguard"
 	"github.com/mjl-/mox/smtp"
 	"github.com/mjl-/mox/smtpclient"
 	"github.com/mjl-/mox/store"
 	"github.com/mjl-/mox/webauth"
 	"github.com/mjl-/mox/webops"
+	"github.com/mjl-/mox/webpush"
 )
 
 //go:embed api.json
@@ -108,12 +115,14 @@ func (w Webmail) LoginPrep(ctx context.Context) string {
 }
 
 // Login returns a session token for the credentials, or fails with error code
-// "user:badLogin". Call LoginPrep to get a loginToken.
-func (w Webmail) Login(ctx context.Context, loginToken, username, password string) store.CSRFToken {
+// "user:badLogin". Call LoginPrep to get a loginToken. If two-factor
+// authentication is enabled and totpCode is empty, the error code is
+// "user:totpRequired", and the login must be retried with a code.
+func (w Webmail) Login(ctx context.Context, loginToken, username, password, totpCode string) store.CSRFToken {
 	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
 	log := reqInfo.Log
 
-	csrfToken, err := webauth.Login(ctx, log, webauth.Accounts, "webmail", w.cookiePath, w.isForwarded, reqInfo.Response, reqInfo.Request, loginToken, username, password)
+	csrfToken, err := webauth.Login(ctx, log, webauth.Accounts, "webmail", w.cookiePath, w.isForwarded, reqInfo.Response, reqInfo.Request, loginToken, username, password, totpCode)
 	if _, ok := err.(*sherpa.Error); ok {
 		panic(err)
 	}
@@ -121,13 +130,72 @@ func (w Webmail) Login(ctx context.Context, loginToken, username, password strin
 	return csrfToken
 }
 
+// PasskeyLoginPrep returns options for logging in with a passkey with
+// navigator.credentials.get in the browser.
+func (w Webmail) PasskeyLoginPrep(ctx context.Context) webauth.PasskeyRequestOptions {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	return webauth.PasskeyLoginPrep("webmail", w.isForwarded, reqInfo.Request)
+}
+
+// PasskeyLogin returns a session token for a login with a passkey, started with
+// PasskeyLoginPrep, or fails with error code "user:loginFailed". Call LoginPrep
+// to get a loginToken after the user selected a passkey.
+func (w Webmail) PasskeyLogin(ctx context.Context, loginToken string, response webauth.PasskeyAssertion) store.CSRFToken {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	log := reqInfo.Log
+
+	csrfToken, err := webauth.PasskeyLogin(ctx, log, webauth.Accounts, "webmail", w.cookiePath, w.isForwarded, reqInfo.Response, reqInfo.Request, loginToken, response)
+	if _, ok := err.(*sherpa.Error); ok {
+		panic(err)
+	}
+	xcheckf(ctx, err, "passkey login")
+	return csrfToken
+}
+
+// OIDCEnabled returns whether single sign-on through an OpenID Connect identity
+// provider is configured.
+func (Webmail) OIDCEnabled(ctx context.Context) bool {
+	return webauth.OIDCEnabled()
+}
+
+// OIDCLoginPrep starts a single sign-on login, returning the URL of the identity
+// provider to navigate to. The identity provider sends the browser back to this
+// page with "code" and "state" query string parameters, for OIDCLogin.
+func (w Webmail) OIDCLoginPrep(ctx context.Context) string {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	log := reqInfo.Log
+
+	authURL, err := webauth.OIDCLoginPrep(ctx, log, "webmail", w.cookiePath, w.isForwarded, reqInfo.Response, reqInfo.Request)
+	if _, ok := err.(*sherpa.Error); ok {
+		panic(err)
+	}
+	xcheckf(ctx, err, "starting single sign-on")
+	return authURL
+}
+
+// OIDCLogin returns a session token for a single sign-on login started with
+// OIDCLoginPrep, or fails with error code "user:loginFailed". Call LoginPrep to
+// get a loginToken.
+func (w Webmail) OIDCLogin(ctx context.Context, loginToken, code, state string) store.CSRFToken {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	log := reqInfo.Log
+
+	csrfToken, err := webauth.OIDCLogin(ctx, log, webauth.Accounts, "webmail", w.cookiePath, w.isForwarded, reqInfo.Response, reqInfo.Request, loginToken, code, state)
+	if _, ok := err.(*sherpa.Error); ok {
+		panic(err)
+	}
+	xcheckf(ctx, err, "single sign-on login")
+	return csrfToken
+}
+
 // Logout invalidates the session token.
 func (w Webmail) Logout(ctx context.Context) {
 	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
 	log := reqInfo.Log
 
-	err := webauth.Logout(ctx, log, webauth.Accounts, "webmail", w.cookiePath, w.isForwarded, reqInfo.Response, reqInfo.Request, reqInfo.Account.Name, reqInfo.SessionToken)
+	err := webauth.Logout(ctx, log, webauth.Accounts, "webmail", w.cookiePath, w.isForwarded, reqInfo.Response, reqInfo.Request, reqInfo.SessionAccountName, reqInfo.SessionToken)
 	xcheckf(ctx, err, "logout")
+	webauth.SetActiveAccount("webmail", w.cookiePath, w.isForwarded, reqInfo.Response, reqInfo.Request, "")
 }
 
 // Token returns a token to use for an SSE connection. A token can only be used for
@@ -135,7 +203,7 @@ func (w Webmail) Logout(ctx context.Context) {
 // with at most 10 unused tokens (the most recently created) per account.
 func (Webmail) Token(ctx context.Context) string {
 	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
-	return sseTokens.xgenerate(ctx, reqInfo.Account.Name, reqInfo.LoginAddress, reqInfo.SessionToken)
+	return sseTokens.xgenerate(ctx, reqInfo.Account.Name, reqInfo.LoginAddress, reqInfo.SessionAccountName, reqInfo.SessionToken)
 }
 
 // Requests sends a new request for an open SSE connection. Any currently active
@@ -181,19 +249,55 @@ func (Webmail) ParsedMessage(ctx context.Context, msgID int64) (pm ParsedMessage
 	return
 }
 
-// fromAddrViewMode returns the view mode for a from address.
+// MessageComposeHTML returns the first HTML part of a message, sanitized for
+// editing in the HTML composer, e.g. when continuing an HTML draft message. Images
+// are only kept when they reference an inline part with a "cid:" URI. An empty
+// string is returned if the message has no HTML part.
+func (Webmail) MessageComposeHTML(ctx context.Context, msgID int64) (html string) {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	log := reqInfo.Log
+	acc := reqInfo.Account
+
+	xdbread(ctx, acc, func(tx *bstore.Tx) {
+		m := xmessageID(ctx, tx, msgID)
+		msgr := acc.MessageReader(m)
+		defer func() {
+			err := msgr.Close()
+			log.Check(err, "closing message reader")
+		}()
+		p, err := m.LoadPart(msgr)
+		xcheckf(ctx, err, "load parsed message")
+
+		hp := htmlPart(&p)
+		if hp == nil {
+			return
+		}
+		buf, err := io.ReadAll(hp.ReaderUTF8OrBinary())
+		xcheckf(ctx, err, "reading html part")
+		html, err = sanitizeHTML(string(buf), func(cid string) bool { return true })
+		xcheckf(ctx, err, "sanitizing html")
+	})
+	return
+}
+
+// fromAddrViewMode returns the view mode for a from address. If there are no
+// settings for the address, settings for its domain, as "@domain", are used.
 func fromAddrViewMode(tx *bstore.Tx, from MessageAddress) (store.ViewMode, error) {
 	lp, err := smtp.ParseLocalpart(from.User)
 	if err != nil {
 		return store.ModeDefault, nil
 	}
 	fromAddr := smtp.Address{Localpart: lp, Domain: from.Domain}.Pack(true)
-	fas := store.FromAddressSettings{FromAddress: fromAddr}
-	err = tx.Get(&fas)
-	if err == bstore.ErrAbsent {
-		return store.ModeDefault, nil
+	for _, k := range []string{fromAddr, "@" + from.Domain.Name()} {
+		fas := store.FromAddressSettings{FromAddress: k}
+		err = tx.Get(&fas)
+		if err == nil {
+			return fas.ViewMode, nil
+		} else if err != bstore.ErrAbsent {
+			return store.ModeDefault, err
+		}
 	}
-	return fas.ViewMode, err
+	return store.ModeDefault, nil
 }
 
 // FromAddressSettingsSave saves per-"From"-address settings.
@@ -230,7 +334,7 @@ func (Webmail) MessageFindMessageID(ctx context.Context, messageID string) (id i
 	}
 
 	xdbread(ctx, acc, func(tx *bstore.Tx) {
-		m, err := bstore.QueryTx[store.Message](tx).FilterNonzero(store.Message{MessageID: messageID}).Get()
+		m, err := bstore.QueryTx[store.Message](tx).FilterNonzero(store.Message{MessageID: store.ThreadKey(acc.Name, messageID)}).Get()
 		if err == bstore.ErrAbsent {
 			return
 		}
@@ -249,8 +353,10 @@ type ComposeMessage struct {
 	ReplyTo           string // If non-empty, Reply-To header to add to message.
 	Subject           string
 	TextBody          string
-	ResponseMessageID int64 // If set, this was a reply or forward, based on IsForward.
-	DraftMessageID    int64 // If set, previous draft message that will be removed after composing new message.
+	HTMLBody          string  // If set, message is stored with text and sanitized HTML version, see SubmitMessage.HTMLBody.
+	ResponseMessageID int64   // If set, this was a reply or forward, based on IsForward.
+	DraftMessageID    int64   // If set, previous draft message that will be removed after composing new message.
+	UploadIDs         []int64 // Uploads to keep with the draft message. Other uploads of the previous draft are removed.
 }
 
 // MessageCompose composes a message and saves it to the mailbox. Used for
@@ -392,14 +498,27 @@ func (w Webmail) MessageCompose(ctx context.Context, m ComposeMessage, mailboxID
 		})
 	}
 	xc.Header("MIME-Version", "1.0")
-	textBody, ct, cte := xc.TextPart("plain", m.TextBody)
-	xc.Header("Content-Type", ct)
-	xc.Header("Content-Transfer-Encoding", cte)
-	xc.Line()
-	xc.Write([]byte(textBody))
+	textBody, htmlBody := m.TextBody, ""
+	if m.HTMLBody != "" {
+		// Inline images are not stored with drafts, but are kept as uploads.
+		cids := map[string]bool{}
+		xdbread(ctx, acc, func(tx *bstore.Tx) {
+			for _, id := range m.UploadIDs {
+				u := store.Upload{ID: id}
+				if err := tx.Get(&u); err == nil && u.ContentID != "" {
+					cids[u.ContentID] = true
+				}
+			}
+		})
+		htmlBody, err = sanitizeHTML(m.HTMLBody, func(cid string) bool { return cids[cid] })
+		xcheckuserf(ctx, err, "sanitizing html")
+		textBody = htmlText(htmlBody)
+	}
+	xwriteBody(ctx, xc, xmessagePart(xc), textBody, htmlBody, nil)
 	xc.Flush()
 
 	var nm store.Message
+	var removedUploads []store.Upload
 
 	// Remove previous draft message, append message to destination mailbox.
 	acc.WithRLock(func() {
@@ -451,6 +570,12 @@ func (w Webmail) MessageCompose(ctx context.Context, m ComposeMessage, mailboxID
 			err = acc.DeliverMessage(log, tx, &nm, dataFile, true, false, false, true)
 			xcheckf(ctx, err, "storing message in mailbox")
 
+			removedUploads, err = acc.UploadsLinkTx(tx, m.UploadIDs, m.DraftMessageID, nm.ID)
+			if errors.Is(err, bstore.ErrAbsent) {
+				xcheckuserf(ctx, err, "keeping uploads with draft")
+			}
+			xcheckf(ctx, err, "keeping uploads with draft")
+
 			changes = append(changes, nm.ChangeAddUID(), mb.ChangeCounts())
 		})
 
@@ -463,6 +588,7 @@ func (w Webmail) MessageCompose(ctx context.Context, m ComposeMessage, mailboxID
 		err := os.Remove(p)
 		log.Check(err, "removing draft message file")
 	}
+	acc.UploadsRemoveFiles(log, removedUploads)
 
 	return nm.ID
 }
@@ -499,6 +625,36 @@ type SubmitMessage struct {
 	FutureRelease      *time.Time // If set, time (in the future) when message should be delivered from queue.
 	ArchiveThread      bool       // If set, thread is archived after sending message.
 	DraftMessageID     int64      // If set, draft message that will be removed after sending.
+	UploadIDs          []int64    // Complete uploads to add as attachments or inline images, see UploadStart.
+
+	// If set, the message is sent as multipart/alternative with a text and an HTML
+	// version. The HTML is sanitized, only basic formatting, links and inline images
+	// are kept. Inline images must reference an upload with a "cid:" URI with the
+	// ContentID of the upload. The text version is derived from the HTML, TextBody is
+	// ignored.
+	HTMLBody string
+
+	// If set, ASCII-armored OpenPGP message with the encrypted MIME entity (text and
+	// attachments), as encrypted by the browser. The message is sent as PGP/MIME
+	// multipart/encrypted message, TextBody and attachments are ignored.
+	PGPEncrypted string
+
+	// If set, the message is sent from this account instead of the active account,
+	// for a From address of the account of the session or an account linked to it.
+	// The message is added to the Sent mailbox of that account. Drafts, uploads and
+	// messages replied to are of the active account.
+	Account string
+}
+
+// SubmitResult is returned by MessageSubmit.
+type SubmitResult struct {
+	// If set, delivery is held until this time, and sending can be undone with
+	// MessageSubmitUndo until then.
+	UndoUntil *time.Time
+
+	QueueMsgIDs   []int64 // Messages added to the queue, one per recipient.
+	SentMessageID int64   // Message added to the Sent mailbox, 0 if there is no Sent mailbox.
+	Account       string  // Account the message was sent from, if not the active account.
 }
 
 // ForwardAttachments references attachments by a list of message.Part paths.
@@ -580,7 +736,10 @@ func xrandom(ctx context.Context, n int) []byte {
 // If a Sent mailbox is configured, messages are added to it after submitting
 // to the delivery queue. If Bcc addresses were present, a header is prepended
 // to the message stored in the Sent mailbox.
-func (w Webmail) MessageSubmit(ctx context.Context, m SubmitMessage) {
+//
+// If undo send is enabled in the settings and no future release is requested,
+// delivery is held for the configured delay, see MessageSubmitUndo.
+func (w Webmail) MessageSubmit(ctx context.Context, m SubmitMessage) (result SubmitResult) {
 	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
 	acc := reqInfo.Account
 	log := reqInfo.Log
@@ -639,8 +798,19 @@ func (w Webmail) MessageSubmit(ctx context.Context, m SubmitMessage) {
 		recipients = append(recipients, addr.Address)
 	}
 
+	// The message can be sent from another account of the session.
+	sendAcc := acc
+	if m.Account != "" && m.Account != acc.Name {
+		sendAcc = xopenLinkedAccount(ctx, reqInfo, m.Account)
+		defer func() {
+			err := sendAcc.Close()
+			log.Check(err, "closing account")
+		}()
+		result.Account = sendAcc.Name
+	}
+
 	// Check if from address is allowed for account.
-	if !mox.AllowMsgFrom(reqInfo.Account.Name, fromAddr.Address) {
+	if !mox.AllowMsgFrom(sendAcc.Name, fromAddr.Address) {
 		metricSubmission.WithLabelValues("badfrom").Inc()
 		xcheckuserf(ctx, errors.New("address not found"), `looking up "from" address for account`)
 	}
@@ -649,13 +819,22 @@ func (w Webmail) MessageSubmit(ctx context.Context, m SubmitMessage) {
 		xcheckuserf(ctx, errors.New("no recipients"), "composing message")
 	}
 
+	// Rewrite sender and recipient addresses if configured, e.g. to masquerade an
+	// internal domain. Done after checking the original from address may be used.
+	fromAddr.Address, _, err = mox.RewriteAddress(fromAddr.Address, true)
+	xcheckf(ctx, err, "rewriting from address")
+	for i, r := range recipients {
+		recipients[i], _, err = mox.RewriteAddress(r, false)
+		xcheckf(ctx, err, "rewriting recipient address")
+	}
+
 	// Check outgoing message rate limit.
-	xdbread(ctx, acc, func(tx *bstore.Tx) {
-		rcpts := make([]smtp.Path, len(recipients))
-		for i, r := range recipients {
-			rcpts[i] = smtp.Path{Localpart: r.Localpart, IPDomain: dns.IPDomain{Domain: r.Domain}}
-		}
-		msglimit, rcptlimit, err := acc.SendLimitReached(tx, rcpts)
+	rcpts := make([]smtp.Path, len(recipients))
+	for i, r := range recipients {
+		rcpts[i] = smtp.Path{Localpart: r.Localpart, IPDomain: dns.IPDomain{Domain: r.Domain}}
+	}
+	xdbread(ctx, sendAcc, func(tx *bstore.Tx) {
+		msglimit, rcptlimit, err := sendAcc.SendLimitReached(tx, rcpts)
 		if msglimit >= 0 {
 			metricSubmission.WithLabelValues("messagelimiterror").Inc()
 			xcheckuserf(ctx, errors.New("message limit reached"), "checking outgoing rate")
@@ -666,6 +845,24 @@ func (w Webmail) MessageSubmit(ctx context.Context, m SubmitMessage) {
 		xcheckf(ctx, err, "checking send limit")
 	})
 
+	var uploads []store.Upload
+	if len(m.UploadIDs) > 0 {
+		xdbread(ctx, acc, func(tx *bstore.Tx) {
+			for _, id := range m.UploadIDs {
+				u := store.Upload{ID: id}
+				err := tx.Get(&u)
+				if err == bstore.ErrAbsent {
+					xcheckuserf(ctx, errors.New("upload does not exist"), "looking up upload")
+				}
+				xcheckf(ctx, err, "looking up upload")
+				if !u.Complete() {
+					xcheckuserf(ctx, fmt.Errorf("upload of %q not complete", u.Filename), "looking up upload")
+				}
+				uploads = append(uploads, u)
+			}
+		})
+	}
+
 	// We only use smtputf8 if we have to, with a utf-8 localpart. For IDNA, we use ASCII domains.
 	smtputf8 := false
 	for _, a := range recipients {
@@ -778,26 +975,79 @@ func (w Webmail) MessageSubmit(ctx context.Context, m SubmitMessage) {
 	if m.RequireTLS != nil && !*m.RequireTLS {
 		xc.Header("TLS-Required", "No")
 	}
+	// Add Autocrypt header with our public key, https://autocrypt.org/level1.html.
+	if pk, err := sendAcc.PGPKeyOwn(ctx, fromAddr.Address.String()); err == nil && pk.Autocrypt {
+		if _, keyData, err := autocrypt.Unarmor(pk.PublicKey); err != nil {
+			log.Errorx("unarmoring own openpgp public key, not adding autocrypt header", err)
+		} else {
+			ah := autocrypt.Header{Addr: fromAddr.Address.String(), KeyData: keyData}
+			xc.Header("Autocrypt", ah.String())
+		}
+	} else if err != nil && err != bstore.ErrAbsent {
+		xcheckf(ctx, err, "looking up openpgp key")
+	}
 	xc.Header("MIME-Version", "1.0")
 
-	if len(m.Attachments) > 0 || len(m.ForwardAttachments.Paths) > 0 {
+	if m.PGPEncrypted != "" {
+		// PGP/MIME, ../rfc/3156
+		if typ, _, err := autocrypt.Unarmor(m.PGPEncrypted); err != nil {
+			xcheckuserf(ctx, err, "parsing encrypted message")
+		} else if typ != "PGP MESSAGE" {
+			xcheckuserf(ctx, fmt.Errorf("armor type %q, expected pgp message", typ), "parsing encrypted message")
+		}
 		mp := multipart.NewWriter(xc)
-		xc.Header("Content-Type", fmt.Sprintf(`multipart/mixed; boundary="%s"`, mp.Boundary()))
+		xc.Header("Content-Type", fmt.Sprintf(`multipart/encrypted; protocol="application/pgp-encrypted"; boundary="%s"`, mp.Boundary()))
 		xc.Line()
 
-		textBody, ct, cte := xc.TextPart("plain", m.TextBody)
-		textHdr := textproto.MIMEHeader{}
-		textHdr.Set("Content-Type", ct)
-		textHdr.Set("Content-Transfer-Encoding", cte)
+		ctlHdr := textproto.MIMEHeader{}
+		ctlHdr.Set("Content-Type", "application/pgp-encrypted")
+		ctlp, err := mp.CreatePart(ctlHdr)
+		xcheckf(ctx, err, "adding control part to message")
+		_, err = ctlp.Write([]byte("Version: 1\r\n"))
+		xcheckf(ctx, err, "writing control part")
+
+		encHdr := textproto.MIMEHeader{}
+		encHdr.Set("Content-Type", `application/octet-stream; name="encrypted.asc"`)
+		encHdr.Set("Content-Disposition", `inline; filename="encrypted.asc"`)
+		encp, err := mp.CreatePart(encHdr)
+		xcheckf(ctx, err, "adding encrypted part to message")
+		_, err = encp.Write([]byte(strings.ReplaceAll(strings.ReplaceAll(m.PGPEncrypted, "\r\n", "\n"), "\n", "\r\n")))
+		xcheckf(ctx, err, "writing encrypted part")
 
-		textp, err := mp.CreatePart(textHdr)
-		xcheckf(ctx, err, "adding text part to message")
-		_, err = textp.Write(textBody)
-		xcheckf(ctx, err, "writing text part")
+		err = mp.Close()
+		xcheckf(ctx, err, "writing mime multipart")
+	} else {
+		// With an HTML body, the text part is derived from the sanitized HTML, and inline
+		// images are added in a multipart/related with the HTML part.
+		textBody, htmlBody := m.TextBody, ""
+		var inline, other []store.Upload
+		if m.HTMLBody != "" {
+			cids := map[string]bool{}
+			for _, u := range uploads {
+				if u.ContentID != "" {
+					cids[u.ContentID] = true
+					inline = append(inline, u)
+				} else {
+					other = append(other, u)
+				}
+			}
+			var err error
+			htmlBody, err = sanitizeHTML(m.HTMLBody, func(cid string) bool { return cids[cid] })
+			xcheckuserf(ctx, err, "sanitizing html")
+			textBody = htmlText(htmlBody)
+		} else {
+			other = uploads
+		}
 
-		xaddPart := func(ct, filename string) io.Writer {
+		// Parts with a content-id are inline images.
+		xaddPart := func(mp *multipart.Writer, ct, filename, contentID string) io.Writer {
 			ahdr := textproto.MIMEHeader{}
-			cd := mime.FormatMediaType("attachment", map[string]string{"filename": filename})
+			disposition := "attachment"
+			if contentID != "" {
+				disposition = "inline"
+				ahdr.Set("Content-ID", "<"+contentID+">")
+			}
+			cd := mime.FormatMediaType(disposition, map[string]string{"filename": filename})
 
 			ahdr.Set("Content-Type", ct)
 			ahdr.Set("Content-Transfer-Encoding", "base64")
@@ -807,8 +1057,8 @@ func (w Webmail) MessageSubmit(ctx context.Context, m SubmitMessage) {
 			return ap
 		}
 
-		xaddAttachmentBase64 := func(ct, filename string, base64Data []byte) {
-			ap := xaddPart(ct, filename)
+		xaddAttachmentBase64 := func(mp *multipart.Writer, ct, filename string, base64Data []byte) {
+			ap := xaddPart(mp, ct, filename, "")
 
 			for len(base64Data) > 0 {
 				line := base64Data
@@ -824,8 +1074,8 @@ func (w Webmail) MessageSubmit(ctx context.Context, m SubmitMessage) {
 			}
 		}
 
-		xaddAttachment := func(ct, filename string, r io.Reader) {
-			ap := xaddPart(ct, filename)
+		xaddAttachment := func(mp *multipart.Writer, ct, filename, contentID string, r io.Reader) {
+			ap := xaddPart(mp, ct, filename, contentID)
 			wc := moxio.Base64Writer(ap)
 			_, err := io.Copy(wc, r)
 			xcheckf(ctx, err, "adding attachment")
@@ -833,92 +1083,151 @@ func (w Webmail) MessageSubmit(ctx context.Context, m SubmitMessage) {
 			xcheckf(ctx, err, "flushing attachment")
 		}
 
-		for _, a := range m.Attachments {
-			s := a.DataURI
-			if !strings.HasPrefix(s, "data:") {
-				xcheckuserf(ctx, errors.New("missing data: in datauri"), "parsing attachment")
-			}
-			s = s[len("data:"):]
-			t := strings.SplitN(s, ",", 2)
-			if len(t) != 2 {
-				xcheckuserf(ctx, errors.New("missing comma in datauri"), "parsing attachment")
+		xaddUpload := func(mp *multipart.Writer, u store.Upload) {
+			params := map[string]string{}
+			ct := u.ContentType
+			if mt, ps, err := mime.ParseMediaType(ct); err == nil {
+				ct, params = mt, ps
 			}
-			if !strings.HasSuffix(t[0], "base64") {
-				xcheckuserf(ctx, errors.New("missing base64 in datauri"), "parsing attachment")
-			}
-			ct := strings.TrimSuffix(t[0], "base64")
-			ct = strings.TrimSuffix(ct, ";")
-			if ct == "" {
-				ct = "application/octet-stream"
-			}
-			filename := a.Filename
-			if filename == "" {
-				filename = "unnamed.bin"
-			}
-			params := map[string]string{"name": filename}
+			params["name"] = u.Filename
 			ct = mime.FormatMediaType(ct, params)
 
-			// Ensure base64 is valid, then we'll write the original string.
-			_, err := io.Copy(io.Discard, base64.NewDecoder(base64.StdEncoding, strings.NewReader(t[1])))
-			xcheckuserf(ctx, err, "parsing attachment as base64")
+			f, err := os.Open(acc.UploadPath(u.ID))
+			xcheckf(ctx, err, "open upload")
+			xaddAttachment(mp, ct, u.Filename, u.ContentID, f)
+			err = f.Close()
+			log.Check(err, "closing upload")
+		}
 
-			xaddAttachmentBase64(ct, filename, []byte(t[1]))
+		var related func(mp *multipart.Writer)
+		if len(inline) > 0 {
+			related = func(mp *multipart.Writer) {
+				for _, u := range inline {
+					xaddUpload(mp, u)
+				}
+			}
 		}
 
-		if len(m.ForwardAttachments.Paths) > 0 {
-			acc.WithRLock(func() {
-				xdbread(ctx, acc, func(tx *bstore.Tx) {
-					fm := xmessageID(ctx, tx, m.ForwardAttachments.MessageID)
-					msgr := acc.MessageReader(fm)
-					defer func() {
-						err := msgr.Close()
-						log.Check(err, "closing message reader")
-					}()
+		if len(m.Attachments) == 0 && len(m.ForwardAttachments.Paths) == 0 && len(other) == 0 {
+			xwriteBody(ctx, xc, xmessagePart(xc), textBody, htmlBody, related)
+		} else {
+			xwriteMultipart(ctx, xmessagePart(xc), "multipart/mixed", func(mp *multipart.Writer) {
+				xwriteBody(ctx, xc, xmultipartPart(ctx, mp), textBody, htmlBody, related)
+
+				for _, a := range m.Attachments {
+					s := a.DataURI
+					if !strings.HasPrefix(s, "data:") {
+						xcheckuserf(ctx, errors.New("missing data: in datauri"), "parsing attachment")
+					}
+					s = s[len("data:"):]
+					t := strings.SplitN(s, ",", 2)
+					if len(t) != 2 {
+						xcheckuserf(ctx, errors.New("missing comma in datauri"), "parsing attachment")
+					}
+					if !strings.HasSuffix(t[0], "base64") {
+						xcheckuserf(ctx, errors.New("missing base64 in datauri"), "parsing attachment")
+					}
+					ct := strings.TrimSuffix(t[0], "base64")
+					ct = strings.TrimSuffix(ct, ";")
+					if ct == "" {
+						ct = "application/octet-stream"
+					}
+					filename := a.Filename
+					if filename == "" {
+						filename = "unnamed.bin"
+					}
+					// Keep parameters of the media type, e.g. "method" for text/calendar.
+					params := map[string]string{}
+					if mt, ps, err := mime.ParseMediaType(ct); err == nil {
+						ct, params = mt, ps
+					}
+					params["name"] = filename
+					ct = mime.FormatMediaType(ct, params)
+					if ct == "" {
+						xcheckuserf(ctx, errors.New("bad content-type in datauri"), "parsing attachment")
+					}
+
+					// Ensure base64 is valid, then we'll write the original string.
+					_, err := io.Copy(io.Discard, base64.NewDecoder(base64.StdEncoding, strings.NewReader(t[1])))
+					xcheckuserf(ctx, err, "parsing attachment as base64")
 
-					fp, err := fm.LoadPart(msgr)
-					xcheckf(ctx, err, "load parsed message")
+					xaddAttachmentBase64(mp, ct, filename, []byte(t[1]))
+				}
 
-					for _, path := range m.ForwardAttachments.Paths {
-						ap := fp
-						for _, xp := range path {
-							if xp < 0 || xp >= len(ap.Parts) {
-								xcheckuserf(ctx, errors.New("unknown part"), "looking up attachment")
+				if len(m.ForwardAttachments.Paths) > 0 {
+					acc.WithRLock(func() {
+						xdbread(ctx, acc, func(tx *bstore.Tx) {
+							fm := xmessageID(ctx, tx, m.ForwardAttachments.MessageID)
+							msgr := acc.MessageReader(fm)
+							defer func() {
+								err := msgr.Close()
+								log.Check(err, "closing message reader")
+							}()
+
+							fp, err := fm.LoadPart(msgr)
+							xcheckf(ctx, err, "load parsed message")
+
+							for _, path := range m.ForwardAttachments.Paths {
+								ap := fp
+								for _, xp := range path {
+									if xp < 0 || xp >= len(ap.Parts) {
+										xcheckuserf(ctx, errors.New("unknown part"), "looking up attachment")
+									}
+									ap = ap.Parts[xp]
+								}
+
+								filename := tryDecodeParam(log, ap.ContentTypeParams["name"])
+								if filename == "" {
+									filename = "unnamed.bin"
+								}
+								params := map[string]string{"name": filename}
+								if pcharset := ap.ContentTypeParams["charset"]; pcharset != "" {
+									params["charset"] = pcharset
+								}
+								ct := strings.ToLower(ap.MediaType + "/" + ap.MediaSubType)
+								ct = mime.FormatMediaType(ct, params)
+								xaddAttachment(mp, ct, filename, "", ap.Reader())
 							}
-							ap = ap.Parts[xp]
-						}
+						})
+					})
+				}
 
-						filename := tryDecodeParam(log, ap.ContentTypeParams["name"])
-						if filename == "" {
-							filename = "unnamed.bin"
-						}
-						params := map[string]string{"name": filename}
-						if pcharset := ap.ContentTypeParams["charset"]; pcharset != "" {
-							params["charset"] = pcharset
-						}
-						ct := strings.ToLower(ap.MediaType + "/" + ap.MediaSubType)
-						ct = mime.FormatMediaType(ct, params)
-						xaddAttachment(ct, filename, ap.Reader())
-					}
-				})
+				for _, u := range other {
+					xaddUpload(mp, u)
+				}
 			})
 		}
-
-		err = mp.Close()
-		xcheckf(ctx, err, "writing mime multipart")
-	} else {
-		textBody, ct, cte := xc.TextPart("plain", m.TextBody)
-		xc.Header("Content-Type", ct)
-		xc.Header("Content-Transfer-Encoding", cte)
-		xc.Line()
-		xc.Write([]byte(textBody))
 	}
 
 	xc.Flush()
 
-	// Add DKIM-Signature headers.
-	var msgPrefix string
+	// Check for signs of a compromised account.
+	if err := sendguard.Check(ctx, log, sendAcc, "webmail", webauth.RemoteIP(log, w.isForwarded, reqInfo.Request), rcpts, dataFile, xc.Size); err != nil {
+		metricSubmission.WithLabelValues("guardrefused").Inc()
+		xcheckuserf(ctx, err, "checking submission")
+	}
+
+	// Add footer, e.g. a legal disclaimer, if configured. The message is rewritten to
+	// a new file.
 	fd := fromAddr.Address.Domain
 	confDom, _ := mox.Conf.Domain(fd)
+	accConf, _ := sendAcc.Conf()
+	dataSize := xc.Size
+	if f := footer.Select(accConf, confDom); f != nil {
+		nf, size, err := footer.Apply(log, *f, dataFile)
+		if err != nil {
+			metricServerErrors.WithLabelValues("footer").Inc()
+		}
+		xcheckf(ctx, err, "adding footer")
+		if nf != nil {
+			defer store.CloseRemoveTempFile(log, nf, "message with footer")
+			dataFile = nf
+			dataSize = size
+		}
+	}
+
+	// Add DKIM-Signature headers.
+	var msgPrefix string
 	selectors := mox.DKIMSelectors(confDom.DKIM)
 	if len(selectors) > 0 {
 		dkimHeaders, err := dkim.Sign(ctx, log.Logger, fromAddr.Address.Localpart, fd, selectors, smtputf8, dataFile)
@@ -930,7 +1239,6 @@ func (w Webmail) MessageSubmit(ctx context.Context, m SubmitMessage) {
 		msgPrefix = dkimHeaders
 	}
 
-	accConf, _ := acc.Conf()
 	loginAddr, err := smtp.ParseAddress(reqInfo.LoginAddress)
 	xcheckf(ctx, err, "parsing login address")
 	useFromID := slices.Contains(accConf.ParsedFromIDLoginAddresses, loginAddr)
@@ -941,6 +1249,17 @@ func (w Webmail) MessageSubmit(ctx context.Context, m SubmitMessage) {
 	}
 	qml := make([]queue.Msg, len(recipients))
 	now := time.Now()
+
+	var undoUntil time.Time
+	if m.FutureRelease == nil {
+		settings := store.Settings{ID: 1}
+		err := acc.DB.Get(ctx, &settings)
+		xcheckf(ctx, err, "get settings")
+		if settings.SendUndoDelay > 0 {
+			undoUntil = now.Add(time.Duration(settings.SendUndoDelay) * time.Second)
+			result.UndoUntil = &undoUntil
+		}
+	}
 	for i, rcpt := range recipients {
 		fp := fromPath
 		var fromID string
@@ -956,7 +1275,7 @@ func (w Webmail) MessageSubmit(ctx context.Context, m SubmitMessage) {
 			recvRcpt = rcpt.Pack(smtputf8)
 		}
 		rcptMsgPrefix := recvHdrFor(recvRcpt) + msgPrefix
-		msgSize := int64(len(rcptMsgPrefix)) + xc.Size
+		msgSize := int64(len(rcptMsgPrefix)) + dataSize
 		toPath := smtp.Path{
 			Localpart: rcpt.Localpart,
 			IPDomain:  dns.IPDomain{Domain: rcpt.Domain},
@@ -973,25 +1292,90 @@ func (w Webmail) MessageSubmit(ctx context.Context, m SubmitMessage) {
 			qm.FutureReleaseRequest = "until;" + m.FutureRelease.Format(time.RFC3339)
 			// todo: possibly add a header to the message stored in the Sent mailbox to indicate it was scheduled for later delivery.
 		}
+		if !undoUntil.IsZero() {
+			qm.NextAttempt = undoUntil
+			qm.HoldUntil = undoUntil
+		}
 		qm.FromID = fromID
 		// no qm.Extra from webmail
 		qml[i] = qm
 	}
-	err = queue.Add(ctx, log, reqInfo.Account.Name, dataFile, qml...)
+	err = queue.Add(ctx, log, sendAcc.Name, dataFile, qml...)
 	if err != nil {
 		metricSubmission.WithLabelValues("queueerror").Inc()
 	}
 	xcheckf(ctx, err, "adding messages to the delivery queue")
 	metricSubmission.WithLabelValues("ok").Inc()
+	for _, qm := range qml {
+		result.QueueMsgIDs = append(result.QueueMsgIDs, qm.ID)
+	}
+
+	// Append message to the Sent mailbox of acc, if there is one.
+	metricked := false
+	xaddSent := func(tx *bstore.Tx, acc *store.Account, modseq store.ModSeq) []store.Change {
+		sentmb, err := bstore.QueryTx[store.Mailbox](tx).FilterEqual("Sent", true).Get()
+		if err == bstore.ErrAbsent {
+			// There is no mailbox designated as Sent mailbox, so we're done.
+			return nil
+		}
+		xcheckf(ctx, err, "message submitted to queue, adding to Sent mailbox")
+
+		if modseq == 0 {
+			modseq, err = acc.NextModSeq(tx)
+			xcheckf(ctx, err, "next modseq")
+		}
+
+		// If there were bcc headers, prepend those to the stored message only, before the
+		// DKIM signature. The DKIM-signature oversigns the bcc header, so this stored
+		// message won't validate with DKIM anymore, which is fine.
+		if len(bccAddrs) > 0 {
+			var sb strings.Builder
+			xbcc := message.NewComposer(&sb, 100*1024, smtputf8)
+			xbcc.HeaderAddrs("Bcc", bccAddrs)
+			xbcc.Flush()
+			msgPrefix = sb.String() + msgPrefix
+		}
+
+		sentm := store.Message{
+			CreateSeq:     modseq,
+			ModSeq:        modseq,
+			MailboxID:     sentmb.ID,
+			MailboxOrigID: sentmb.ID,
+			Flags:         store.Flags{Notjunk: true, Seen: true},
+			Size:          int64(len(msgPrefix)) + dataSize,
+			Sealed:        store.MessageSealed{MsgPrefix: []byte(msgPrefix)},
+		}
+
+		if ok, maxSize, err := acc.CanAddMessageSize(tx, sentm.Size); err != nil {
+			xcheckf(ctx, err, "checking quota")
+		} else if !ok {
+			xcheckuserf(ctx, fmt.Errorf("account over maximum total message size %d", maxSize), "checking quota")
+		}
+
+		// Update mailbox before delivery, which changes uidnext.
+		sentmb.Add(sentm.MailboxCounts())
+		err = tx.Update(&sentmb)
+		xcheckf(ctx, err, "updating sent mailbox for counts")
+
+		err = acc.DeliverMessage(log, tx, &sentm, dataFile, true, false, false, true)
+		if err != nil {
+			metricSubmission.WithLabelValues("storesenterror").Inc()
+			metricked = true
+		}
+		xcheckf(ctx, err, "message submitted to queue, appending message to Sent mailbox")
+
+		result.SentMessageID = sentm.ID
+		return []store.Change{sentm.ChangeAddUID(), sentmb.ChangeCounts()}
+	}
 
 	var modseq store.ModSeq // Only set if needed.
+	var removedUploads []store.Upload
 
 	// Append message to Sent mailbox, mark original messages as answered/forwarded,
 	// remove any draft message.
 	acc.WithRLock(func() {
 		var changes []store.Change
 
-		metricked := false
 		defer func() {
 			if x := recover(); x != nil {
 				if !metricked {
@@ -1008,6 +1392,20 @@ func (w Webmail) MessageSubmit(ctx context.Context, m SubmitMessage) {
 				// On-disk file is removed after lock.
 			}
 
+			// Remove the uploads of the message and its draft. If sending can be undone, the
+			// uploads of the message are kept for a while, without draft message, so they can
+			// be sent again.
+			var err error
+			removedUploads, err = acc.UploadsLinkTx(tx, m.UploadIDs, m.DraftMessageID, 0)
+			xcheckf(ctx, err, "removing uploads of draft")
+			if undoUntil.IsZero() {
+				for _, u := range uploads {
+					err := tx.Delete(&u)
+					xcheckf(ctx, err, "removing upload")
+					removedUploads = append(removedUploads, u)
+				}
+			}
+
 			if m.ResponseMessageID > 0 {
 				rm := xmessageID(ctx, tx, m.ResponseMessageID)
 				oflags := rm.Flags
@@ -1027,7 +1425,7 @@ func (w Webmail) MessageSubmit(ctx context.Context, m SubmitMessage) {
 					xcheckf(ctx, err, "updating flags of replied/forwarded message")
 					changes = append(changes, rm.ChangeFlags(oflags))
 
-					err = acc.RetrainMessages(ctx, log, tx, []store.Message{rm}, false)
+					err = acc.RetrainFlagChanges(ctx, log, tx, []store.Message{rm})
 					xcheckf(ctx, err, "retraining messages after reply/forward")
 				}
 
@@ -1054,69 +1452,158 @@ func (w Webmail) MessageSubmit(ctx context.Context, m SubmitMessage) {
 				}
 			}
 
-			sentmb, err := bstore.QueryTx[store.Mailbox](tx).FilterEqual("Sent", true).Get()
-			if err == bstore.ErrAbsent {
-				// There is no mailbox designated as Sent mailbox, so we're done.
-				return
+			if sendAcc == acc {
+				changes = append(changes, xaddSent(tx, acc, modseq)...)
 			}
-			xcheckf(ctx, err, "message submitted to queue, adding to Sent mailbox")
+		})
 
-			if modseq == 0 {
-				modseq, err = acc.NextModSeq(tx)
-				xcheckf(ctx, err, "next modseq")
-			}
+		store.BroadcastChanges(acc, changes)
+	})
 
-			// If there were bcc headers, prepend those to the stored message only, before the
-			// DKIM signature. The DKIM-signature oversigns the bcc header, so this stored
-			// message won't validate with DKIM anymore, which is fine.
-			if len(bccAddrs) > 0 {
-				var sb strings.Builder
-				xbcc := message.NewComposer(&sb, 100*1024, smtputf8)
-				xbcc.HeaderAddrs("Bcc", bccAddrs)
-				xbcc.Flush()
-				msgPrefix = sb.String() + msgPrefix
-			}
+	if sendAcc != acc {
+		sendAcc.WithRLock(func() {
+			var changes []store.Change
+			defer func() {
+				if x := recover(); x != nil {
+					if !metricked {
+						metricServerErrors.WithLabelValues("submit").Inc()
+					}
+					panic(x)
+				}
+			}()
+			xdbwrite(ctx, sendAcc, func(tx *bstore.Tx) {
+				changes = xaddSent(tx, sendAcc, 0)
+			})
+			store.BroadcastChanges(sendAcc, changes)
+		})
+	}
 
-			sentm := store.Message{
-				CreateSeq:     modseq,
-				ModSeq:        modseq,
-				MailboxID:     sentmb.ID,
-				MailboxOrigID: sentmb.ID,
-				Flags:         store.Flags{Notjunk: true, Seen: true},
-				Size:          int64(len(msgPrefix)) + xc.Size,
-				MsgPrefix:     []byte(msgPrefix),
-			}
+	// Remove on-disk file for removed draft message.
+	if m.DraftMessageID > 0 {
+		p := acc.MessagePath(m.DraftMessageID)
+		err := os.Remove(p)
+		log.Check(err, "removing draft message file")
+	}
+	acc.UploadsRemoveFiles(log, removedUploads)
 
-			if ok, maxSize, err := acc.CanAddMessageSize(tx, sentm.Size); err != nil {
-				xcheckf(ctx, err, "checking quota")
-			} else if !ok {
-				xcheckuserf(ctx, fmt.Errorf("account over maximum total message size %d", maxSize), "checking quota")
-			}
+	return result
+}
 
-			// Update mailbox before delivery, which changes uidnext.
-			sentmb.Add(sentm.MailboxCounts())
-			err = tx.Update(&sentmb)
-			xcheckf(ctx, err, "updating sent mailbox for counts")
+// MessageSubmitUndo cancels delivery of a message submitted with MessageSubmit
+// while it is still held in the queue, and removes the message from the Sent
+// mailbox. Flags set on the message replied to or forwarded are kept. An error is
+// returned if the delivery can no longer be canceled.
+func (Webmail) MessageSubmitUndo(ctx context.Context, result SubmitResult) {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc := reqInfo.Account
+	log := reqInfo.Log
 
-			err = acc.DeliverMessage(log, tx, &sentm, dataFile, true, false, false, true)
-			if err != nil {
-				metricSubmission.WithLabelValues("storesenterror").Inc()
-				metricked = true
-			}
-			xcheckf(ctx, err, "message submitted to queue, appending message to Sent mailbox")
+	if result.Account != "" && result.Account != acc.Name {
+		acc = xopenLinkedAccount(ctx, reqInfo, result.Account)
+		defer func() {
+			err := acc.Close()
+			log.Check(err, "closing account")
+		}()
+	}
 
-			changes = append(changes, sentm.ChangeAddUID(), sentmb.ChangeCounts())
-		})
+	err := queue.Undo(ctx, log, acc.Name, result.QueueMsgIDs)
+	if errors.Is(err, queue.ErrUndoExpired) {
+		xcheckuserf(ctx, err, "canceling delivery")
+	}
+	xcheckf(ctx, err, "canceling delivery")
 
+	if result.SentMessageID == 0 {
+		return
+	}
+	// The message isn't removed if the user already moved or removed it.
+	var removed bool
+	acc.WithRLock(func() {
+		var changes []store.Change
+		xdbwrite(ctx, acc, func(tx *bstore.Tx) {
+			m := store.Message{ID: result.SentMessageID}
+			err := tx.Get(&m)
+			if err == bstore.ErrAbsent || err == nil && m.Expunged {
+				return
+			}
+			xcheckf(ctx, err, "get sent message")
+			if mb := xmailboxID(ctx, tx, m.MailboxID); !mb.Sent {
+				return
+			}
+			_, changes = xops.MessageDeleteTx(ctx, log, tx, acc, []int64{m.ID}, 0)
+			removed = true
+		})
 		store.BroadcastChanges(acc, changes)
 	})
 
-	// Remove on-disk file for removed draft message.
-	if m.DraftMessageID > 0 {
-		p := acc.MessagePath(m.DraftMessageID)
-		err := os.Remove(p)
-		log.Check(err, "removing draft message file")
+	if removed {
+		p := acc.MessagePath(result.SentMessageID)
+		err = os.Remove(p)
+		log.Check(err, "removing sent message file")
+	}
+}
+
+// UploadStart starts an upload of a file, to be added to a message being composed
+// as attachment, or as inline image if inline is set. The data is sent in chunks
+// with PUT requests to "upload/<id>?offset=<offset>", which can be resumed after
+// interruptions. Inline images get a Content-ID for referencing with "cid:".
+func (w Webmail) UploadStart(ctx context.Context, filename, contentType string, size int64, inline bool) store.Upload {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc := reqInfo.Account
+	log := reqInfo.Log
+
+	for _, c := range filename + contentType {
+		if c < 0x20 {
+			xcheckuserf(ctx, errors.New("control characters not allowed"), "checking filename and content-type")
+		}
+	}
+	if filename == "" {
+		filename = "unnamed.bin"
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	} else if _, _, err := mime.ParseMediaType(contentType); err != nil {
+		xcheckuserf(ctx, err, "parsing content-type")
+	}
+	if size < 0 || size > w.maxMessageSize {
+		xcheckuserf(ctx, fmt.Errorf("size must be between 0 and %d bytes", w.maxMessageSize), "checking upload size")
+	}
+
+	u := store.Upload{
+		Filename:    filename,
+		ContentType: contentType,
+		Size:        size,
+	}
+	if inline {
+		u.ContentID = fmt.Sprintf("%s@%s", xrandomID(ctx, 16), mox.Conf.Static.HostnameDomain.ASCII)
+	}
+	err := acc.UploadAdd(ctx, log, &u)
+	xcheckf(ctx, err, "adding upload")
+	return u
+}
+
+// UploadRemove removes an upload, e.g. when an attachment is removed from a
+// message being composed.
+func (Webmail) UploadRemove(ctx context.Context, uploadID int64) {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc := reqInfo.Account
+	log := reqInfo.Log
+
+	err := acc.UploadRemove(ctx, log, uploadID)
+	if err == bstore.ErrAbsent {
+		xcheckuserf(ctx, err, "removing upload")
 	}
+	xcheckf(ctx, err, "removing upload")
+}
+
+// DraftUploads returns the uploads kept with a draft message, for continuing to
+// compose the draft.
+func (Webmail) DraftUploads(ctx context.Context, draftMessageID int64) []store.Upload {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc := reqInfo.Account
+
+	l, err := acc.Uploads(ctx, draftMessageID)
+	xcheckf(ctx, err, "listing uploads")
+	return l
 }
 
 // MessageMove moves messages to another mailbox. If the message is already in
@@ -1334,8 +1821,9 @@ func (Webmail) MailboxRename(ctx context.Context, mailboxID int64, newName strin
 }
 
 // CompleteRecipient returns autocomplete matches for a recipient, returning the
-// matches, most recently used first, and whether this is the full list and further
-// requests for longer prefixes aren't necessary.
+// matches, contacts from the address book first, then other recipients most
+// recently used first, and whether this is the full list and further requests for
+// longer prefixes aren't necessary.
 func (Webmail) CompleteRecipient(ctx context.Context, search string) ([]string, bool) {
 	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
 	acc := reqInfo.Account
@@ -1352,9 +1840,35 @@ func (Webmail) CompleteRecipient(ctx context.Context, search string) ([]string,
 			}
 			seen := map[key]bool{}
 
+			// Contacts match on name and email address.
+			contacts, err := bstore.QueryTx[store.Contact](tx).List()
+			xcheckf(ctx, err, "listing contacts")
+			sort.SliceStable(contacts, func(i, j int) bool {
+				return strings.ToLower(contacts[i].Name) < strings.ToLower(contacts[j].Name)
+			})
+			for _, c := range contacts {
+				nameMatch := c.Name != "" && strings.Contains(strings.ToLower(c.Name), search)
+				for _, email := range c.Emails {
+					addr, err := smtp.ParseAddress(email)
+					if err != nil {
+						continue
+					}
+					k := key{addr.Localpart.String(), addr.Domain.Name()}
+					if seen[k] || !nameMatch && !strings.Contains(strings.ToLower(email), search) {
+						continue
+					}
+					if len(matches) >= 20 {
+						all = false
+						return
+					}
+					matches = append(matches, addressString(message.Address{Name: c.Name, User: addr.Localpart.String(), Host: addr.Domain.ASCII}, false))
+					seen[k] = true
+				}
+			}
+
 			q := bstore.QueryTx[store.Recipient](tx)
 			q.SortDesc("Sent")
-			err := q.ForEach(func(r store.Recipient) error {
+			err = q.ForEach(func(r store.Recipient) error {
 				k := key{r.Localpart, r.Domain}
 				if seen[k] {
 					return nil
@@ -1373,9 +1887,9 @@ func (Webmail) CompleteRecipient(ctx context.Context, search string) ([]string,
 				m := store.Message{ID: r.MessageID}
 				err := tx.Get(&m)
 				xcheckf(ctx, err, "get sent message")
-				if !m.Expunged && m.ParsedBuf != nil {
+				if !m.Expunged && m.Sealed.ParsedBuf != nil {
 					var part message.Part
-					err := json.Unmarshal(m.ParsedBuf, &part)
+					err := json.Unmarshal(m.Sealed.ParsedBuf, &part)
 					xcheckf(ctx, err, "parsing part")
 
 					dom, err := dns.ParseDomain(r.Domain)
@@ -1590,8 +2104,212 @@ func (Webmail) ThreadMute(ctx context.Context, messageIDs []int64, mute bool) {
 	})
 }
 
-// SecurityResult indicates whether a security feature is supported.
-type SecurityResult string
+// xthreadMessageIDs returns the IDs of all non-expunged messages in the threads
+// of messageIDs. If sameMailbox is set, only messages that are in the same mailbox
+// as one of messageIDs are returned.
+func xthreadMessageIDs(ctx context.Context, tx *bstore.Tx, messageIDs []int64, sameMailbox bool) []int64 {
+	if len(messageIDs) == 0 {
+		xcheckuserf(ctx, errors.New("no messages"), "gathering thread messages")
+	}
+
+	threadIDs := map[int64]struct{}{}
+	mailboxIDs := map[int64]struct{}{}
+	for _, id := range messageIDs {
+		if id == 0 {
+			xcheckuserf(ctx, errors.New("invalid zero message id"), "get message")
+		}
+		m := store.Message{ID: id}
+		err := tx.Get(&m)
+		if err == bstore.ErrAbsent || err == nil && m.Expunged {
+			xcheckuserf(ctx, errors.New("no such message"), "get message")
+		}
+		xcheckf(ctx, err, "get message")
+		threadIDs[m.ThreadID] = struct{}{}
+		mailboxIDs[m.MailboxID] = struct{}{}
+	}
+
+	var ids []int64
+	q := bstore.QueryTx[store.Message](tx)
+	q.FilterEqual("ThreadID", slicesAny(maps.Keys(threadIDs))...)
+	q.FilterEqual("Expunged", false)
+	if sameMailbox {
+		q.FilterEqual("MailboxID", slicesAny(maps.Keys(mailboxIDs))...)
+	}
+	q.SortAsc("ID")
+	err := q.IDs(&ids)
+	xcheckf(ctx, err, "listing thread messages")
+	return ids
+}
+
+// ThreadArchive moves all messages of the threads of messageIDs that are in the
+// same mailbox as one of messageIDs to the designated Archive mailbox. Messages
+// of the thread in other mailboxes, e.g. Sent, are left alone.
+func (Webmail) ThreadArchive(ctx context.Context, messageIDs []int64) {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc := reqInfo.Account
+	log := reqInfo.Log
+
+	acc.WithRLock(func() {
+		var changes []store.Change
+
+		xdbwrite(ctx, acc, func(tx *bstore.Tx) {
+			mbArchive, err := bstore.QueryTx[store.Mailbox](tx).FilterEqual("Archive", true).Get()
+			if err == bstore.ErrAbsent {
+				xcheckuserf(ctx, errors.New("not configured"), "looking up designated archive mailbox")
+			}
+			xcheckf(ctx, err, "looking up designated archive mailbox")
+
+			var msgIDs []int64
+			for _, id := range xthreadMessageIDs(ctx, tx, messageIDs, true) {
+				m := store.Message{ID: id}
+				err := tx.Get(&m)
+				xcheckf(ctx, err, "get message")
+				if m.MailboxID != mbArchive.ID {
+					msgIDs = append(msgIDs, id)
+				}
+			}
+			if len(msgIDs) > 0 {
+				_, changes = xops.MessageMoveTx(ctx, log, acc, tx, msgIDs, mbArchive, 0)
+			}
+		})
+
+		store.BroadcastChanges(acc, changes)
+	})
+}
+
+// ThreadSeen marks all messages of the threads of messageIDs as read, or as
+// unread if seen is false. Messages of the threads in all mailboxes are changed.
+func (Webmail) ThreadSeen(ctx context.Context, messageIDs []int64, seen bool) {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc := reqInfo.Account
+	log := reqInfo.Log
+
+	// Gather the messages and change their flags in a single transaction, so messages
+	// added to or removed from the thread in between are not missed or an error.
+	acc.WithRLock(func() {
+		var changes []store.Change
+
+		xdbwrite(ctx, acc, func(tx *bstore.Tx) {
+			msgIDs := xthreadMessageIDs(ctx, tx, messageIDs, false)
+			if seen {
+				_, changes = xops.MessageFlagsAddTx(ctx, log, acc, tx, msgIDs, store.Flags{Seen: true}, nil, 0)
+			} else {
+				_, changes = xops.MessageFlagsClearTx(ctx, log, acc, tx, msgIDs, store.Flags{Seen: true}, nil, 0)
+			}
+		})
+
+		store.BroadcastChanges(acc, changes)
+	})
+}
+
+// InviteReply replies to the organizer of a calendar invitation in a message, with
+// status "ACCEPTED", "TENTATIVE" or "DECLINED". The reply is sent from the
+// address of the account that is an attendee of the event. Accepted and tentative
+// events are stored in the calendar of the account, declined events are removed
+// from it.
+func (w Webmail) InviteReply(ctx context.Context, messageID int64, status string) {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc := reqInfo.Account
+	log := reqInfo.Log
+
+	var verb string
+	switch status {
+	case "ACCEPTED":
+		verb = "Accepted"
+	case "TENTATIVE":
+		verb = "Tentative"
+	case "DECLINED":
+		verb = "Declined"
+	default:
+		xcheckuserf(ctx, errors.New("unknown status"), "checking status")
+	}
+
+	var cal ical.Component
+	xdbread(ctx, acc, func(tx *bstore.Tx) {
+		m := xmessageID(ctx, tx, messageID)
+		state := msgState{acc: acc}
+		defer state.clear()
+		if !state.ensurePart(m, true) {
+			xcheckf(ctx, state.err, "parsing message")
+		}
+		p, _, ok := findCalendarPart(*state.part, []int{})
+		if !ok {
+			xcheckuserf(ctx, errors.New("no text/calendar part"), "looking up invitation in message")
+		}
+		var err error
+		cal, err = ical.Parse(&moxio.LimitReader{R: p.ReaderUTF8OrBinary(), Limit: 1024 * 1024})
+		xcheckuserf(ctx, err, "parsing invitation")
+	})
+	if !strings.EqualFold(cal.Text("METHOD"), "REQUEST") {
+		xcheckuserf(ctx, errors.New("not an invitation request"), "checking invitation")
+	}
+	inv := parseInvite(cal, nil)
+	if inv == nil {
+		xcheckuserf(ctx, errors.New("no event in invitation"), "checking invitation")
+	}
+	if inv.Organizer.Email == "" {
+		xcheckuserf(ctx, errors.New("no organizer in invitation"), "checking invitation")
+	}
+
+	// Find the attendee that is us.
+	var attendee string
+	for _, a := range inv.Attendees {
+		addr, err := smtp.ParseAddress(a.Email)
+		if err == nil && mox.AllowMsgFrom(acc.Name, addr) {
+			attendee = a.Email
+			break
+		}
+	}
+	if attendee == "" {
+		xcheckuserf(ctx, errors.New("none of the attendees is an address of this account"), "looking up attendee")
+	}
+
+	reply, err := ical.Reply(cal, attendee, status, time.Now())
+	xcheckuserf(ctx, err, "composing reply")
+
+	summary := inv.Summary
+	if summary == "" {
+		summary = "(no summary)"
+	}
+	w.MessageSubmit(ctx, SubmitMessage{
+		From:     attendee,
+		To:       []string{inv.Organizer.Email},
+		Subject:  verb + ": " + summary,
+		TextBody: fmt.Sprintf("%s has replied %q to the invitation for %q.\n", attendee, strings.ToLower(verb), summary),
+		Attachments: []File{
+			{
+				Filename: "reply.ics",
+				DataURI:  "data:text/calendar;method=REPLY;charset=utf-8;base64," + base64.StdEncoding.EncodeToString(reply.Marshal()),
+			},
+		},
+		ResponseMessageID: messageID,
+	})
+
+	if status == "DECLINED" {
+		err := acc.CalendarEventRemoveUID(ctx, inv.UID)
+		xcheckf(ctx, err, "removing event from calendar")
+		return
+	}
+
+	// Store event with our updated participation status.
+	for i, c := range cal.Components {
+		if c.Name != "VEVENT" {
+			continue
+		}
+		for j, p := range c.Props {
+			if p.Name == "ATTENDEE" && strings.EqualFold(p.Address(), attendee) {
+				p.SetParam("PARTSTAT", status)
+				cal.Components[i].Props[j] = p
+			}
+		}
+	}
+	_, err = acc.CalendarEventSave(ctx, cal)
+	xcheckf(ctx, err, "storing event in calendar")
+	log.Debug("replied to invitation", slog.String("uid", inv.UID), slog.String("status", status))
+}
+
+// SecurityResult indicates whether a security feature is supported.
+type SecurityResult string
 
 const (
 	SecurityResultError SecurityResult = "error"
@@ -1784,6 +2502,13 @@ func (Webmail) SettingsSave(ctx context.Context, settings store.Settings) {
 	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
 	acc := reqInfo.Account
 
+	if settings.SendUndoDelay < 0 || settings.SendUndoDelay > 60 {
+		xcheckuserf(ctx, errors.New("must be between 0 and 60 seconds"), "checking undo send delay")
+	}
+	if settings.Language != "" && !i18n.Known(settings.Language) {
+		xcheckuserf(ctx, errors.New("unknown language"), "checking language")
+	}
+
 	settings.ID = 1
 	err := acc.DB.Update(ctx, &settings)
 	xcheckf(ctx, err, "save settings")
@@ -1799,10 +2524,10 @@ func (Webmail) RulesetSuggestMove(ctx context.Context, msgID, mbSrcID, mbDstID i
 		mbSrc := xmailboxID(ctx, tx, mbSrcID)
 		mbDst := xmailboxID(ctx, tx, mbDstID)
 
-		if m.RcptToLocalpart == "" && m.RcptToDomain == "" {
+		if m.Sealed.RcptToLocalpart == "" && m.Sealed.RcptToDomain == "" {
 			return
 		}
-		rcptTo = m.RcptToLocalpart.String() + "@" + m.RcptToDomain
+		rcptTo = m.Sealed.RcptToLocalpart.String() + "@" + m.Sealed.RcptToDomain
 
 		conf, _ := acc.Conf()
 		dest := conf.Destinations[rcptTo] // May not be present.
@@ -1895,10 +2620,10 @@ func (Webmail) RulesetSuggestMove(ctx context.Context, msgID, mbSrcID, mbDstID i
 			}
 		} else {
 			// Otherwise, try to make a rule based on message "From" address.
-			if m.MsgFromLocalpart == "" && m.MsgFromDomain == "" {
+			if m.Sealed.MsgFromLocalpart == "" && m.MsgFromDomain == "" {
 				return
 			}
-			msgFrom = m.MsgFromLocalpart.String() + "@" + m.MsgFromDomain
+			msgFrom = m.Sealed.MsgFromLocalpart.String() + "@" + m.MsgFromDomain
 
 			no := store.RulesetNoMsgFrom{
 				RcptToAddress:  rcptTo,
@@ -2041,6 +2766,607 @@ func (Webmail) RulesetMailboxNever(ctx context.Context, mailboxID int64, toMailb
 	xcheckf(ctx, err, "storing user response")
 }
 
+// FilterRules returns the filter rules for incoming messages, in order of
+// evaluation.
+func (Webmail) FilterRules(ctx context.Context) []store.FilterRule {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc := reqInfo.Account
+
+	rules, err := acc.FilterRules(ctx)
+	xcheckf(ctx, err, "listing filter rules")
+	return rules
+}
+
+// FilterRuleSave adds a filter rule if its ID is 0, or updates an existing rule.
+// New rules are evaluated after existing rules.
+func (Webmail) FilterRuleSave(ctx context.Context, rule store.FilterRule) store.FilterRule {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc := reqInfo.Account
+
+	rule, err := acc.FilterRuleSave(ctx, rule)
+	if errors.Is(err, store.ErrFilterRuleParam) || errors.Is(err, bstore.ErrAbsent) {
+		xcheckuserf(ctx, err, "saving filter rule")
+	}
+	xcheckf(ctx, err, "saving filter rule")
+	return rule
+}
+
+// FilterRuleRemove removes a filter rule.
+func (Webmail) FilterRuleRemove(ctx context.Context, ruleID int64) {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc := reqInfo.Account
+
+	err := acc.FilterRuleRemove(ctx, ruleID)
+	if err == bstore.ErrAbsent {
+		xcheckuserf(ctx, err, "removing filter rule")
+	}
+	xcheckf(ctx, err, "removing filter rule")
+}
+
+// FilterRulesReorder sets the order in which filter rules are evaluated. All
+// rule IDs must be present.
+func (Webmail) FilterRulesReorder(ctx context.Context, ruleIDs []int64) {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc := reqInfo.Account
+
+	err := acc.FilterRulesReorder(ctx, ruleIDs)
+	if errors.Is(err, store.ErrFilterRuleParam) || errors.Is(err, bstore.ErrAbsent) {
+		xcheckuserf(ctx, err, "reordering filter rules")
+	}
+	xcheckf(ctx, err, "reordering filter rules")
+}
+
+// FilterRuleApply runs a filter rule on the existing messages in a mailbox, and
+// returns the number of matching messages. Matching messages are marked and moved
+// as configured in the rule, discarded messages are moved to the Trash mailbox.
+// Messages are not forwarded.
+func (Webmail) FilterRuleApply(ctx context.Context, ruleID, mailboxID int64) (count int) {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc := reqInfo.Account
+	log := reqInfo.Log
+
+	var rule store.FilterRule
+	var ids []int64
+	var dstMailboxID int64
+	xdbread(ctx, acc, func(tx *bstore.Tx) {
+		rule = store.FilterRule{ID: ruleID}
+		err := tx.Get(&rule)
+		if err == bstore.ErrAbsent {
+			xcheckuserf(ctx, err, "get filter rule")
+		}
+		xcheckf(ctx, err, "get filter rule")
+		rule.Disabled = false
+
+		mb := store.Mailbox{ID: mailboxID}
+		err = tx.Get(&mb)
+		if err == bstore.ErrAbsent {
+			xcheckuserf(ctx, err, "get mailbox")
+		}
+		xcheckf(ctx, err, "get mailbox")
+
+		if rule.Discard {
+			mbTrash, err := bstore.QueryTx[store.Mailbox](tx).FilterEqual("Trash", true).Get()
+			if err == bstore.ErrAbsent {
+				xcheckuserf(ctx, errors.New("not configured"), "looking up designated trash mailbox")
+			}
+			xcheckf(ctx, err, "looking up designated trash mailbox")
+			dstMailboxID = mbTrash.ID
+		} else if rule.Mailbox != "" {
+			mbDst, err := acc.MailboxFind(tx, rule.Mailbox)
+			xcheckf(ctx, err, "looking up mailbox of rule")
+			if mbDst == nil {
+				xcheckuserf(ctx, fmt.Errorf("mailbox %q does not exist", rule.Mailbox), "looking up mailbox of rule")
+			}
+			dstMailboxID = mbDst.ID
+		}
+		if dstMailboxID == mb.ID {
+			dstMailboxID = 0
+		}
+
+		q := bstore.QueryTx[store.Message](tx)
+		q.FilterNonzero(store.Message{MailboxID: mb.ID})
+		q.FilterEqual("Expunged", false)
+		err = q.ForEach(func(m store.Message) error {
+			msgr := acc.MessageReader(m)
+			defer func() {
+				err := msgr.Close()
+				log.Check(err, "closing message reader")
+			}()
+			part, err := m.LoadPart(msgr)
+			if err != nil {
+				log.Debugx("loading message part for filter rule", err, slog.Int64("msgid", m.ID))
+				return nil
+			}
+			if rule.Match(log, &part, m.Size) {
+				ids = append(ids, m.ID)
+			}
+			return nil
+		})
+		xcheckf(ctx, err, "evaluating filter rule on messages")
+	})
+	if len(ids) == 0 {
+		return 0
+	}
+
+	var flaglist []string
+	if rule.Seen {
+		flaglist = append(flaglist, `\seen`)
+	}
+	if rule.Flagged {
+		flaglist = append(flaglist, `\flagged`)
+	}
+	flaglist = append(flaglist, rule.Keywords...)
+	if len(flaglist) > 0 {
+		xops.MessageFlagsAdd(ctx, log, acc, ids, flaglist)
+	}
+	if dstMailboxID != 0 {
+		xops.MessageMove(ctx, log, acc, ids, "", dstMailboxID)
+	}
+	return len(ids)
+}
+
+// RetentionRules returns the retention rules for old messages in mailboxes.
+func (Webmail) RetentionRules(ctx context.Context) []store.RetentionRule {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc := reqInfo.Account
+
+	rules, err := acc.RetentionRules(ctx)
+	xcheckf(ctx, err, "listing retention rules")
+	return rules
+}
+
+// RetentionRuleSave adds a retention rule if its ID is 0, or updates an existing
+// rule. Rules are applied periodically in the background.
+func (Webmail) RetentionRuleSave(ctx context.Context, rule store.RetentionRule) store.RetentionRule {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc := reqInfo.Account
+
+	rule, err := acc.RetentionRuleSave(ctx, rule)
+	if errors.Is(err, store.ErrRetentionRuleParam) || errors.Is(err, bstore.ErrAbsent) {
+		xcheckuserf(ctx, err, "saving retention rule")
+	}
+	xcheckf(ctx, err, "saving retention rule")
+	return rule
+}
+
+// RetentionRuleRemove removes a retention rule.
+func (Webmail) RetentionRuleRemove(ctx context.Context, ruleID int64) {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc := reqInfo.Account
+
+	err := acc.RetentionRuleRemove(ctx, ruleID)
+	if err == bstore.ErrAbsent {
+		xcheckuserf(ctx, err, "removing retention rule")
+	}
+	xcheckf(ctx, err, "removing retention rule")
+}
+
+// RetentionRulePreview returns the messages that a rule, which does not have to
+// be saved, would move or remove when applied now, without changing anything.
+func (Webmail) RetentionRulePreview(ctx context.Context, rule store.RetentionRule) retention.Result {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc := reqInfo.Account
+
+	rule, err := store.CheckRetentionRule(rule)
+	xcheckuserf(ctx, err, "checking retention rule")
+	result, err := retention.Apply(ctx, reqInfo.Log, acc, rule, time.Now(), true)
+	xcheckf(ctx, err, "evaluating retention rule")
+	return result
+}
+
+// RetentionRuleApply applies a saved rule now, instead of waiting for the
+// periodic run in the background. The result is recorded in the rule.
+func (Webmail) RetentionRuleApply(ctx context.Context, ruleID int64) retention.Result {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc := reqInfo.Account
+
+	rule := store.RetentionRule{ID: ruleID}
+	err := acc.DB.Get(ctx, &rule)
+	if err == bstore.ErrAbsent {
+		xcheckuserf(ctx, err, "get retention rule")
+	}
+	xcheckf(ctx, err, "get retention rule")
+
+	result, applyErr := retention.Apply(ctx, reqInfo.Log, acc, rule, time.Now(), rule.DryRun)
+	err = acc.RetentionRuleResult(ctx, rule.ID, result.Matched, applyErr)
+	xcheckf(ctx, err, "storing result of retention rule")
+	xcheckf(ctx, applyErr, "applying retention rule")
+	return result
+}
+
+// PGPKeys returns the OpenPGP keys of the account, both own keys and keys of
+// correspondents. Private keys are not included, see PGPPrivateKey.
+func (Webmail) PGPKeys(ctx context.Context) []store.PGPKey {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc := reqInfo.Account
+
+	keys, err := acc.PGPKeys(ctx)
+	xcheckf(ctx, err, "listing openpgp keys")
+	return keys
+}
+
+// PGPKeyImport adds an ASCII-armored OpenPGP public key for an address. For own
+// keys, privateKey is the ASCII-armored secret key, protected with a passphrase,
+// and the address must belong to the account. Keys are generated and private keys
+// are encrypted in the browser. An existing key for the address is replaced.
+func (Webmail) PGPKeyImport(ctx context.Context, address, publicKey, privateKey string) store.PGPKey {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc := reqInfo.Account
+
+	pk, err := acc.PGPKeyImport(ctx, address, publicKey, privateKey)
+	if errors.Is(err, store.ErrPGPKeyParam) {
+		xcheckuserf(ctx, err, "importing openpgp key")
+	}
+	xcheckf(ctx, err, "importing openpgp key")
+	return pk
+}
+
+// PGPKeySave saves whether an own key is sent in Autocrypt headers, and whether it
+// is published in the Web Key Directory.
+func (Webmail) PGPKeySave(ctx context.Context, keyID int64, autocrypt, wkdPublish bool) {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc := reqInfo.Account
+
+	err := acc.PGPKeySave(ctx, keyID, autocrypt, wkdPublish)
+	if errors.Is(err, store.ErrPGPKeyParam) || err == bstore.ErrAbsent {
+		xcheckuserf(ctx, err, "saving openpgp key")
+	}
+	xcheckf(ctx, err, "saving openpgp key")
+}
+
+// PGPKeyRemove removes an OpenPGP key.
+func (Webmail) PGPKeyRemove(ctx context.Context, keyID int64) {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc := reqInfo.Account
+
+	err := acc.PGPKeyRemove(ctx, keyID)
+	if err == bstore.ErrAbsent {
+		xcheckuserf(ctx, err, "removing openpgp key")
+	}
+	xcheckf(ctx, err, "removing openpgp key")
+}
+
+// PGPPrivateKey returns the passphrase-protected ASCII-armored private key for an
+// own key, for decrypting and signing in the browser.
+func (Webmail) PGPPrivateKey(ctx context.Context, keyID int64) string {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc := reqInfo.Account
+
+	pk := store.PGPKey{ID: keyID}
+	err := acc.DB.Get(ctx, &pk)
+	if err == bstore.ErrAbsent || err == nil && !pk.Own {
+		xcheckuserf(ctx, errors.New("no private key"), "get openpgp key")
+	}
+	xcheckf(ctx, err, "get openpgp key")
+	return pk.PrivateKey
+}
+
+// PGPRecipientKeys returns the known public keys for recipient addresses, for
+// encrypting a message in the browser. Addresses without known key are not in the
+// result.
+func (Webmail) PGPRecipientKeys(ctx context.Context, addresses []string) []store.PGPKey {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc := reqInfo.Account
+
+	var l []string
+	for _, s := range addresses {
+		addr, err := parseAddress(s)
+		xcheckuserf(ctx, err, "parsing address")
+		l = append(l, addr.Address.String())
+	}
+	keys, err := acc.PGPKeysPeer(ctx, l)
+	xcheckf(ctx, err, "listing openpgp keys")
+	return keys
+}
+
+// LinkedAccount is an account that can be used in a webmail session: the account
+// of the session itself, or an account linked to it.
+type LinkedAccount struct {
+	LinkID       int64 // ID of the link, 0 for the account of the session.
+	Account      string
+	LoginAddress string           // Empty for the account of the session if it is not active.
+	Addresses    []MessageAddress // Addresses that can be used as From address.
+	Active       bool             // Whether requests of the session are for this account.
+	Unread       int64            // Messages in Inbox without Seen flag.
+
+	// Mailboxes of the account, for showing the folder tree of accounts that are not
+	// active. Mailboxes of the active account are sent over the SSE connection
+	// instead, so they are not set for the active account.
+	Mailboxes []store.Mailbox
+}
+
+// xopenLinkedAccount opens the account of the session or an account linked to it.
+// The caller must close the account.
+func xopenLinkedAccount(ctx context.Context, reqInfo requestInfo, name string) *store.Account {
+	log := reqInfo.Log
+
+	if name == reqInfo.SessionAccountName {
+		acc, err := store.OpenAccount(log, name)
+		xcheckf(ctx, err, "open account")
+		return acc
+	}
+
+	sacc, err := store.OpenAccount(log, reqInfo.SessionAccountName)
+	xcheckf(ctx, err, "open session account")
+	link, err := sacc.AccountLinkGet(ctx, name)
+	xerr := sacc.Close()
+	log.Check(xerr, "closing account")
+	if err == bstore.ErrAbsent {
+		xcheckuserf(ctx, errors.New("account not linked"), "looking up linked account")
+	}
+	xcheckf(ctx, err, "looking up linked account")
+	acc, err := store.AccountLinkOpen(ctx, log, link)
+	if err != nil && (errors.Is(err, store.ErrLoginDisabled) || errors.Is(err, store.ErrAccountLinkInvalid) || errors.Is(err, store.ErrAccountUnknown)) {
+		xcheckuserf(ctx, err, "open linked account")
+	}
+	xcheckf(ctx, err, "open linked account")
+	return acc
+}
+
+// xsessionAccounts calls fn for the account of the session, with a zero link,
+// and for each account linked to it, sorted by name. Links that can never become
+// valid again because the credentials of the linked account changed are removed.
+// Links to accounts with disabled logins, or to removed accounts, are skipped.
+func xsessionAccounts(ctx context.Context, reqInfo requestInfo, fn func(link store.AccountLink, acc *store.Account)) {
+	log := reqInfo.Log
+
+	sacc, err := store.OpenAccount(log, reqInfo.SessionAccountName)
+	xcheckf(ctx, err, "open session account")
+	defer func() {
+		err := sacc.Close()
+		log.Check(err, "closing account")
+	}()
+	links, err := sacc.AccountLinks(ctx)
+	xcheckf(ctx, err, "listing linked accounts")
+
+	fn(store.AccountLink{}, sacc)
+	for _, link := range links {
+		acc, err := store.AccountLinkOpen(ctx, log, link)
+		if err != nil && errors.Is(err, store.ErrAccountLinkInvalid) {
+			// Credentials of the linked account changed, the link can never become valid
+			// again.
+			log.Info("removing link to account with changed credentials", slog.String("account", sacc.Name), slog.String("linkedaccount", link.Account))
+			err := sacc.AccountLinkRemove(ctx, link.ID)
+			log.Check(err, "removing invalid account link")
+			continue
+		} else if err != nil && (errors.Is(err, store.ErrLoginDisabled) || errors.Is(err, store.ErrAccountUnknown)) {
+			// Link is ineffective while logins are disabled, or after the account was removed.
+			continue
+		}
+		xcheckf(ctx, err, "open linked account")
+		func() {
+			defer func() {
+				err := acc.Close()
+				log.Check(err, "closing account")
+			}()
+			fn(link, acc)
+		}()
+	}
+}
+
+// LinkedAccounts returns the account of the session and the accounts linked to
+// it, with their addresses, number of unread messages in their Inbox, and for
+// accounts that are not active their mailboxes, for showing a folder tree of all
+// accounts alongside the mailboxes of the active account.
+func (Webmail) LinkedAccounts(ctx context.Context) []LinkedAccount {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+
+	var l []LinkedAccount
+	xsessionAccounts(ctx, reqInfo, func(link store.AccountLink, acc *store.Account) {
+		la := LinkedAccount{LinkID: link.ID, Account: acc.Name, LoginAddress: link.LoginAddress}
+		la.Active = la.Account == reqInfo.Account.Name
+		if la.Active {
+			la.LoginAddress = reqInfo.LoginAddress
+		}
+		accConf, _ := acc.Conf()
+		la.Addresses = xaccountAddresses(ctx, accConf)
+		mailboxes, err := bstore.QueryDB[store.Mailbox](ctx, acc.DB).SortAsc("Name").List()
+		xcheckf(ctx, err, "listing mailboxes")
+		for _, mb := range mailboxes {
+			if mb.Name == "Inbox" {
+				la.Unread = mb.Unread
+			}
+		}
+		if !la.Active {
+			la.Mailboxes = mailboxes
+		}
+		l = append(l, la)
+	})
+	return l
+}
+
+// UnifiedPage holds pagination parameters for UnifiedInbox.
+type UnifiedPage struct {
+	// Last message of the previous page, for fetching the next messages. Zero for the
+	// first page.
+	AnchorReceived  time.Time
+	AnchorAccount   string
+	AnchorMessageID int64
+
+	// Number of messages to return, must be >= 1, we never return more than 1000 for
+	// one request.
+	Count int
+}
+
+// UnifiedMessage is a message in the unified inbox.
+type UnifiedMessage struct {
+	Account     string // Account of the message.
+	MessageItem MessageItem
+}
+
+// unifiedBefore returns whether message a comes before message b in the unified
+// inbox: most recently received first, then by account name and message ID.
+func unifiedBefore(aReceived time.Time, aAccount string, aID int64, bReceived time.Time, bAccount string, bID int64) bool {
+	if !aReceived.Equal(bReceived) {
+		return aReceived.After(bReceived)
+	}
+	if aAccount != bAccount {
+		return aAccount < bAccount
+	}
+	return aID > bID
+}
+
+// UnifiedInbox returns messages in the Inbox of the account of the session and of
+// the accounts linked to it, merged into a single list, most recently received
+// first. Fewer than page.Count messages are returned at the end of the list.
+func (Webmail) UnifiedInbox(ctx context.Context, page UnifiedPage) []UnifiedMessage {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	log := reqInfo.Log
+
+	if page.Count < 1 || page.Count > 1000 {
+		xcheckuserf(ctx, errors.New("count must be between 1 and 1000"), "checking page")
+	}
+	anchor := !page.AnchorReceived.IsZero()
+
+	var l []UnifiedMessage
+	xsessionAccounts(ctx, reqInfo, func(link store.AccountLink, acc *store.Account) {
+		xdbread(ctx, acc, func(tx *bstore.Tx) {
+			mb, err := bstore.QueryTx[store.Mailbox](tx).FilterNonzero(store.Mailbox{Name: "Inbox"}).Get()
+			if err == bstore.ErrAbsent {
+				return
+			}
+			xcheckf(ctx, err, "get inbox")
+
+			q := bstore.QueryTx[store.Message](tx)
+			q.FilterNonzero(store.Message{MailboxID: mb.ID})
+			q.FilterEqual("Expunged", false)
+			if anchor {
+				q.FilterLessEqual("Received", page.AnchorReceived)
+				q.FilterFn(func(m store.Message) bool {
+					return unifiedBefore(page.AnchorReceived, page.AnchorAccount, page.AnchorMessageID, m.Received, acc.Name, m.ID)
+				})
+			}
+			q.SortDesc("Received")
+			defer q.Close()
+
+			// We read messages received at the same time as the last message we need, their
+			// order is not by ID in the index.
+			state := msgState{acc: acc}
+			defer state.clear()
+			var n int
+			var last time.Time
+			for {
+				m, err := q.Next()
+				if err == bstore.ErrAbsent {
+					break
+				}
+				xcheckf(ctx, err, "listing messages")
+				if n >= page.Count && !m.Received.Equal(last) {
+					break
+				}
+				mi, err := messageItem(log, m, &state)
+				xcheckf(ctx, err, "making message item")
+				l = append(l, UnifiedMessage{acc.Name, mi})
+				n++
+				last = m.Received
+			}
+		})
+	})
+
+	sort.Slice(l, func(i, j int) bool {
+		a, b := l[i], l[j]
+		return unifiedBefore(a.MessageItem.Message.Received, a.Account, a.MessageItem.Message.ID, b.MessageItem.Message.Received, b.Account, b.MessageItem.Message.ID)
+	})
+	if len(l) > page.Count {
+		l = l[:page.Count]
+	}
+	return l
+}
+
+// LinkedAccountAdd links another account to the account of the session, after
+// verifying its credentials, allowing the session to switch to the account and
+// to send messages from its addresses. Errors are as for Login. The link remains
+// until removed with LinkedAccountRemove, also for new sessions. It stops working
+// when the password or two-factor authentication of the linked account changes,
+// and while logins to the linked account are disabled.
+func (w Webmail) LinkedAccountAdd(ctx context.Context, username, password, totpCode string) {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	log := reqInfo.Log
+
+	_, err := webauth.LinkAccount(ctx, log, "webmail", w.isForwarded, reqInfo.Request, reqInfo.SessionAccountName, username, password, totpCode)
+	if _, ok := err.(*sherpa.Error); ok {
+		panic(err)
+	}
+	xcheckf(ctx, err, "linking account")
+}
+
+// LinkedAccountRemove removes the link to an account. If it is the active account,
+// the session switches back to the account of the session.
+func (w Webmail) LinkedAccountRemove(ctx context.Context, linkID int64) {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	log := reqInfo.Log
+
+	sacc, err := store.OpenAccount(log, reqInfo.SessionAccountName)
+	xcheckf(ctx, err, "open session account")
+	defer func() {
+		err := sacc.Close()
+		log.Check(err, "closing account")
+	}()
+	link := store.AccountLink{ID: linkID}
+	err = sacc.DB.Get(ctx, &link)
+	if err == nil {
+		err = sacc.AccountLinkRemove(ctx, linkID)
+	}
+	if err == bstore.ErrAbsent {
+		xcheckuserf(ctx, err, "removing linked account")
+	}
+	xcheckf(ctx, err, "removing linked account")
+	if link.Account == reqInfo.Account.Name {
+		webauth.SetActiveAccount("webmail", w.cookiePath, w.isForwarded, reqInfo.Response, reqInfo.Request, "")
+	}
+}
+
+// LinkedAccountActivate makes an account active for the session, either the
+// account of the session or an account linked to it. The client must reload its
+// state, e.g. by reconnecting, because mailboxes and messages are of the active
+// account.
+func (w Webmail) LinkedAccountActivate(ctx context.Context, account string) {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	log := reqInfo.Log
+
+	acc := xopenLinkedAccount(ctx, reqInfo, account)
+	err := acc.Close()
+	log.Check(err, "closing account")
+	if account == reqInfo.SessionAccountName {
+		account = ""
+	}
+	webauth.SetActiveAccount("webmail", w.cookiePath, w.isForwarded, reqInfo.Response, reqInfo.Request, account)
+}
+
+// PushKey returns the public key of the server for Web Push, for subscribing in
+// the browser.
+func (Webmail) PushKey(ctx context.Context) (publicKey string) {
+	publicKey, err := webpush.PublicKey()
+	xcheckf(ctx, err, "get web push key")
+	return publicKey
+}
+
+// PushSubscribe adds a Web Push subscription of this browser, for notifications
+// about new messages in the Inbox. P256dh and auth are the base64url-encoded keys
+// of the subscription. Label describes the device.
+func (Webmail) PushSubscribe(ctx context.Context, endpoint, p256dh, auth, label string) {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc := reqInfo.Account
+
+	err := webpush.CheckSubscription(endpoint, p256dh, auth)
+	xcheckuserf(ctx, err, "checking subscription")
+	if r := []rune(label); len(r) > 100 {
+		label = string(r[:100])
+	}
+	ps := store.PushSubscription{Endpoint: endpoint, P256DH: p256dh, Auth: auth, Label: label}
+	err = acc.PushSubscriptionAdd(ctx, &ps)
+	xcheckf(ctx, err, "adding push subscription")
+}
+
+// PushUnsubscribe removes the Web Push subscription for endpoint, of this browser.
+func (Webmail) PushUnsubscribe(ctx context.Context, endpoint string) {
+	reqInfo := ctx.Value(requestInfoCtxKey).(requestInfo)
+	acc := reqInfo.Account
+
+	err := acc.PushSubscriptionRemoveEndpoint(ctx, endpoint)
+	xcheckf(ctx, err, "removing push subscription")
+}
+
 func slicesAny[T any](l []T) []any {
 	r := make([]any, len(l))
 	for i, v := range l {
@@ -2,6 +2,24 @@
 
 namespace api {
 
+// PasskeyRequestOptions are the parameters for logging in with a passkey with
+// navigator.credentials.get in the browser.
+export interface PasskeyRequestOptions {
+	Challenge: string
+	RPID: string
+	Timeout: number  // In milliseconds.
+}
+
+// PasskeyAssertion is the response from navigator.credentials.get, with
+// base64url encoded values.
+export interface PasskeyAssertion {
+	CredentialID: string
+	ClientDataJSON: string
+	AuthenticatorData: string
+	Signature: string
+	UserHandle: string  // User ID from registration, identifying the account.
+}
+
 // Request is a request to an SSE connection to send messages, either for a new
 // view, to continue with an existing view, or to a cancel an ongoing request.
 export interface Request {
@@ -68,6 +86,7 @@ export interface ParsedMessage {
 	Texts?: string[] | null  // Text parts, can be empty.
 	HasHTML: boolean  // Whether there is an HTML part. The webclient renders HTML message parts through an iframe and a separate request with strict CSP headers to prevent script execution and loading of external resources, which isn't possible when loading in iframe with inline HTML because not all browsers support the iframe csp attribute.
 	ListReplyAddress?: MessageAddress | null  // From List-Post.
+	Invite?: Invite | null  // Calendar invitation, from the first text/calendar part, if any.
 }
 
 // Part represents a whole mail message, or a part of a multipart message. It
@@ -128,6 +147,29 @@ export interface Domain {
 	Unicode: string  // Name as U-labels, in Unicode NFC. Empty if this is an ASCII-only domain. No trailing dot.
 }
 
+// Invite is a calendar invitation (iMIP, RFC 6047), or a reply or cancellation
+// for an event.
+export interface Invite {
+	Path?: number[] | null  // Path to the text/calendar part in the message.
+	Method: string  // E.g. "REQUEST", "REPLY" or "CANCEL".
+	UID: string
+	Summary: string
+	Location: string
+	Description: string
+	Start: Date
+	End: Date  // Zero if absent.
+	AllDay: boolean  // If set, Start and End are dates without time, and End is exclusive.
+	Organizer: InviteAttendee
+	Attendees?: InviteAttendee[] | null
+}
+
+// InviteAttendee is an organizer or attendee of an event.
+export interface InviteAttendee {
+	Name: string
+	Email: string
+	Status: string  // Participation status, e.g. "NEEDS-ACTION", "ACCEPTED", "TENTATIVE", "DECLINED".
+}
+
 // FromAddressSettings are webmail client settings per "From" address.
 export interface FromAddressSettings {
 	FromAddress: string  // Unicode.
@@ -143,8 +185,10 @@ export interface ComposeMessage {
 	ReplyTo: string  // If non-empty, Reply-To header to add to message.
 	Subject: string
 	TextBody: string
+	HTMLBody: string  // If set, message is stored with text and sanitized HTML version, see SubmitMessage.HTMLBody.
 	ResponseMessageID: number  // If set, this was a reply or forward, based on IsForward.
 	DraftMessageID: number  // If set, previous draft message that will be removed after composing new message.
+	UploadIDs?: number[] | null  // Uploads to keep with the draft message. Other uploads of the previous draft are removed.
 }
 
 // SubmitMessage is an email message to be sent to one or more recipients.
@@ -167,6 +211,10 @@ export interface SubmitMessage {
 	FutureRelease?: Date | null  // If set, time (in the future) when message should be delivered from queue.
 	ArchiveThread: boolean  // If set, thread is archived after sending message.
 	DraftMessageID: number  // If set, draft message that will be removed after sending.
+	UploadIDs?: number[] | null  // Complete uploads to add as attachments or inline images, see UploadStart.
+	HTMLBody: string  // If set, the message is sent as multipart/alternative with a text and an HTML version. The HTML is sanitized, only basic formatting, links and inline images are kept. Inline images must reference an upload with a "cid:" URI with the ContentID of the upload. The text version is derived from the HTML, TextBody is ignored.
+	PGPEncrypted: string  // If set, ASCII-armored OpenPGP message with the encrypted MIME entity (text and attachments), as encrypted by the browser. The message is sent as PGP/MIME multipart/encrypted message, TextBody and attachments are ignored.
+	Account: string  // If set, the message is sent from this account instead of the active account, for a From address of the account of the session or an account linked to it. The message is added to the Sent mailbox of that account. Drafts, uploads and messages replied to are of the active account.
 }
 
 // File is a new attachment (not from an existing message that is being
@@ -182,6 +230,29 @@ export interface ForwardAttachments {
 	Paths?: (number[] | null)[] | null  // List of attachments, each path is a list of indices into the top-level message.Part.Parts.
 }
 
+// SubmitResult is returned by MessageSubmit.
+export interface SubmitResult {
+	UndoUntil?: Date | null  // If set, delivery is held until this time, and sending can be undone with MessageSubmitUndo until then.
+	QueueMsgIDs?: number[] | null  // Messages added to the queue, one per recipient.
+	SentMessageID: number  // Message added to the Sent mailbox, 0 if there is no Sent mailbox.
+	Account: string  // Account the message was sent from, if not the active account.
+}
+
+// Upload is a file uploaded through webmail, to be added as attachment or inline
+// image to a message being composed. Uploads are transferred in chunks and can be
+// resumed. They are kept with the draft message they belong to, and removed after
+// sending, or when their draft is removed.
+export interface Upload {
+	ID: number
+	Created: Date
+	DraftMessageID: number  // Draft message the upload belongs to, 0 while not saved with a draft.
+	Filename: string
+	ContentType: string  // E.g. "image/png".
+	ContentID: string  // For inline images, without <>, for referencing with "cid:".
+	Size: number  // Total size, as announced when starting the upload.
+	Received: number  // Bytes stored so far. The upload is complete when equal to Size.
+}
+
 // Mailbox is collection of messages, e.g. Inbox or Sent.
 export interface Mailbox {
 	ID: number
@@ -200,6 +271,7 @@ export interface Mailbox {
 	Unread: number  // Messages without \Seen, excluding those with \Deleted, for JMAP.
 	Unseen: number  // Messages without \Seen, including those with \Deleted, for IMAP.
 	Size: number  // Number of bytes for all messages.
+	DeletedSize: number  // Number of bytes for messages with \Deleted flag, i.e. the storage that is reclaimed by expunging. For IMAP STATUS DELETED-STORAGE.
 }
 
 // RecipientSecurity is a quick analysis of the security properties of delivery to
@@ -218,6 +290,8 @@ export interface Settings {
 	Signature: string
 	Quoting: Quoting
 	ShowAddressSecurity: boolean  // Whether to show the bars underneath the address input fields indicating starttls/dnssec/dane/mtasts/requiretls support by address.
+	SendUndoDelay: number  // Seconds messages submitted through webmail are held in the queue before delivery is attempted, during which sending can be undone. Zero disables undo send.
+	Language: string  // Language code for the web interfaces and messages generated by mox, such as DSNs. Empty for the browser default in web interfaces, and English for messages.
 }
 
 export interface Ruleset {
@@ -234,55 +308,106 @@ export interface Ruleset {
 	ListAllowDNSDomain: Domain
 }
 
-// EventStart is the first message sent on an SSE connection, giving the client
-// basic data to populate its UI. After this event, messages will follow quickly in
-// an EventViewMsgs event.
-export interface EventStart {
-	SSEID: number
-	LoginAddress: MessageAddress
-	Addresses?: MessageAddress[] | null
-	DomainAddressConfigs?: { [key: string]: DomainAddressConfig }  // ASCII domain to address config.
-	MailboxName: string
-	Mailboxes?: Mailbox[] | null
-	RejectsMailbox: string
-	Settings: Settings
-	AccountPath: string  // If nonempty, the path on same host to webaccount interface.
-	Version: string
-}
-
-// DomainAddressConfig has the address (localpart) configuration for a domain, so
-// the webmail client can decide if an address matches the addresses of the
-// account.
-export interface DomainAddressConfig {
-	LocalpartCatchallSeparator: string  // Can be empty.
-	LocalpartCaseSensitive: boolean
-}
-
-// EventViewErr indicates an error during a query for messages. The request is
-// aborted, no more request-related messages will be sent until the next request.
-export interface EventViewErr {
-	ViewID: number
-	RequestID: number
-	Err: string  // To be displayed in client.
+// FilterRule is a filtering rule for incoming messages of an account, managed by
+// the user in webmail. Rules are evaluated in order of Position during delivery,
+// after the rulesets from the configuration file. The first matching rule is
+// applied. All non-empty conditions must match. Conditions are case-insensitive
+// substring matches.
+export interface FilterRule {
+	ID: number
+	Position: number  // Order of evaluation, lower first.
+	Name: string
+	Disabled: boolean
+	From: string  // Conditions.; Name or address in From header.
+	To: string  // Name or address in To or Cc header.
+	Subject: string
+	HeaderName: string  // Header to match, with HeaderValue. E.g. "List-Id".
+	HeaderValue: string  // If empty, only the presence of HeaderName is required.
+	SizeMin: number  // Minimum message size in bytes, if > 0.
+	SizeMax: number  // Maximum message size in bytes, if > 0.
+	Mailbox: string  // Actions.; Deliver to this mailbox instead of the default.
+	Seen: boolean  // Mark message as read.
+	Flagged: boolean  // Mark message as flagged.
+	Keywords?: string[] | null  // Keywords to add to message.
+	ForwardTo: string  // Send a copy of the message to this address.
+	Discard: boolean  // Do not store the message.
+}
+
+// RetentionRule is a rule for messages in a mailbox that are older than a number
+// of days, e.g. for moving messages from Inbox to an archive mailbox, or for
+// removing messages from Trash and Junk. Rules are managed by the user in webmail,
+// and applied periodically in the background. A rule in dry-run mode only
+// records the number of matching messages.
+export interface RetentionRule {
+	ID: number
+	Mailbox: string  // Mailbox the rule applies to, not including child mailboxes.
+	AgeDays: number  // Rule applies to messages received at least this many days ago.
+	Action: RetentionAction
+	Destination: string  // Mailbox for the archive actions. Created if it does not exist.
+	Disabled: boolean
+	DryRun: boolean  // Only report the number of matching messages, don't change anything.
+	From: string  // Optional conditions. Case-insensitive substring matches, like FilterRule.; Name or address in From header.
+	Subject: string
+	KeepFlagged: boolean  // Don't apply rule to flagged messages.
+	KeepUnread: boolean  // Don't apply rule to unread messages.
+	LastRun: Date  // Result of most recent run in the background.
+	LastMatched: number  // Number of messages matched, and moved or removed if not DryRun.
+	LastError: string  // Empty if the last run was successful.
 }
 
-// EventViewReset indicates that a request for the next set of messages in a few
-// could not be fulfilled, e.g. because the anchor message does not exist anymore.
-// The client should clear its list of messages. This can happen before
-// EventViewMsgs events are sent.
-export interface EventViewReset {
-	ViewID: number
-	RequestID: number
+// Result is the outcome of evaluating a rule.
+export interface Result {
+	Matched: number  // Number of messages matched.
+	Size: number  // Total size of matched messages.
 }
 
-// EventViewMsgs contains messages for a view, possibly a continuation of an
-// earlier list of messages.
-export interface EventViewMsgs {
-	ViewID: number
-	RequestID: number
-	MessageItems?: (MessageItem[] | null)[] | null  // If empty, this was the last message for the request. If non-empty, a list of thread messages. Each with the first message being the reason this thread is included and can be used as AnchorID in followup requests. If the threading mode is "off" in the query, there will always be only a single message. If a thread is sent, all messages in the thread are sent, including those that don't match the query (e.g. from another mailbox). Threads can be displayed based on the ThreadParentIDs field, with possibly slightly different display based on field ThreadMissingLink.
-	ParsedMessage?: ParsedMessage | null  // If set, will match the target page.DestMessageID from the request.
-	ViewEnd: boolean  // If set, there are no more messages in this view at this moment. Messages can be added, typically via Change messages, e.g. for new deliveries.
+// PGPKey is an OpenPGP key, either of the account itself (with a private key,
+// used by webmail for decrypting and signing), or of a correspondent (used for
+// encrypting messages to them). Keys of correspondents are imported by the user,
+// or learned from Autocrypt headers in incoming messages.
+// 
+// Encryption, decryption and signing is done in the browser. The server only
+// stores the private key as armored data that is encrypted with a passphrase by
+// the OpenPGP implementation.
+export interface PGPKey {
+	ID: number
+	Created: Date
+	Address: string  // Normalized email address the key is for.
+	Fingerprint: string  // Of primary key, upper case hex.
+	UserIDs?: string[] | null
+	PublicKey: string  // Armored transferable public key.
+	Own: boolean  // Whether this is a key of the account itself, with a private key.
+	Autocrypt: boolean  // For own keys, whether the public key is added as Autocrypt header to messages sent from webmail.
+	WKDPublish: boolean  // For own keys, whether the public key is published in the Web Key Directory.
+	Source: string  // "import" or "autocrypt".
+	PreferEncrypt: boolean  // From Autocrypt header.
+	AutocryptTimestamp: Date  // Date of the most recent message with an Autocrypt header for the address.
+}
+
+// LinkedAccount is an account that can be used in a webmail session: the account
+// of the session itself, or an account linked to it.
+export interface LinkedAccount {
+	LinkID: number  // ID of the link, 0 for the account of the session.
+	Account: string
+	LoginAddress: string  // Empty for the account of the session if it is not active.
+	Addresses?: MessageAddress[] | null  // Addresses that can be used as From address.
+	Active: boolean  // Whether requests of the session are for this account.
+	Unread: number  // Messages in Inbox without Seen flag.
+	Mailboxes?: Mailbox[] | null  // Mailboxes of the account, for showing the folder tree of accounts that are not active. Mailboxes of the active account are sent over the SSE connection instead, so they are not set for the active account.
+}
+
+// UnifiedPage holds pagination parameters for UnifiedInbox.
+export interface UnifiedPage {
+	AnchorReceived: Date  // Last message of the previous page, for fetching the next messages. Zero for the first page.
+	AnchorAccount: string
+	AnchorMessageID: number
+	Count: number  // Number of messages to return, must be >= 1, we never return more than 1000 for one request.
+}
+
+// UnifiedMessage is a message in the unified inbox.
+export interface UnifiedMessage {
+	Account: string  // Account of the message.
+	MessageItem: MessageItem
 }
 
 // MessageItem is sent by queries, it has derived information analyzed from
@@ -322,13 +447,8 @@ export interface Message {
 	RemoteIPMasked2: string  // For IPv4 /26, for IPv6 /48.
 	RemoteIPMasked3: string  // For IPv4 /21, for IPv6 /32.
 	EHLODomain: string  // Only set if present and not an IP address. Unicode string. Empty for forwarded messages.
-	MailFrom: string  // With localpart and domain. Can be empty.
-	MailFromLocalpart: Localpart  // SMTP "MAIL FROM", can be empty.
-	MailFromDomain: string  // Only set if it is a domain, not an IP. Unicode string. Empty for forwarded messages, but see OrigMailFromDomain.
-	RcptToLocalpart: Localpart  // SMTP "RCPT TO", can be empty.
-	RcptToDomain: string  // Unicode string.
-	MsgFromLocalpart: Localpart  // Parsed "From" message header, used for reputation along with domain validation.
-	MsgFromDomain: string  // Unicode string.
+	MailFromDomain: string  // Only set if it is a domain, not an IP. Unicode string. Empty for forwarded messages, but see OrigMailFromDomain. The full SMTP "MAIL FROM" address is in Sealed.
+	MsgFromDomain: string  // Domain of parsed "From" message header, used for reputation along with domain validation. The localpart is in Sealed.; Unicode string.
 	MsgFromOrgDomain: string  // Unicode string.
 	EHLOValidated: boolean  // Simplified statements of the Validation fields below, used for incoming messages to check reputation.
 	MailFromValidated: boolean
@@ -339,8 +459,8 @@ export interface Message {
 	DKIMDomains?: string[] | null  // Domains with verified DKIM signatures. Unicode string. For forwarded messages, a DKIM domain that matched a ruleset's verified domain is left out, but included in OrigDKIMDomains.
 	OrigEHLODomain: string  // For forwarded messages,
 	OrigDKIMDomains?: string[] | null
-	MessageID: string  // Canonicalized Message-Id, always lower-case and normalized quoting, without <>'s. Empty if missing. Used for matching message threads, and to prevent duplicate reject delivery.
-	SubjectBase: string  // For matching threads in case there is no References/In-Reply-To header. It is lower-cased, white-space collapsed, mailing list tags and re/fwd tags removed.
+	MessageID: string  // Canonicalized Message-Id, always lower-case and normalized quoting, without <>'s. Empty if missing. Used for matching message threads, and to prevent duplicate reject delivery. With encryption at rest, a keyed hash, see ThreadKey.
+	SubjectBase: string  // For matching threads in case there is no References/In-Reply-To header. It is lower-cased, white-space collapsed, mailing list tags and re/fwd tags removed. With encryption at rest, a keyed hash, like MessageID.
 	MessageHash?: string | null  // Hash of message. For rejects delivery in case there is no Message-ID, only set when delivered as reject.
 	ThreadID: number  // ID of message starting this thread.
 	ThreadParentIDs?: number[] | null  // IDs of parent messages, from closest parent to the root message. Parent messages may be in a different mailbox, or may no longer exist. ThreadParentIDs must never contain the message id itself (a cycle), and parent messages must reference the same ancestors.
@@ -352,6 +472,7 @@ export interface Message {
 	ReceivedTLSVersion: number  // 0 if unknown, 1 if plaintext/no TLS, otherwise TLS cipher suite.
 	ReceivedTLSCipherSuite: number
 	ReceivedRequireTLS: boolean  // Whether RequireTLS was known to be used for incoming delivery.
+	ReceivedTLSFingerprint: string  // JA4 fingerprint of the TLS client, if known. For recognizing software of senders.
 	Seen: boolean
 	Answered: boolean
 	Flagged: boolean
@@ -365,8 +486,10 @@ export interface Message {
 	Keywords?: string[] | null  // For keywords other than system flags or the basic well-known $-flags. Only in "atom" syntax (IMAP), they are case-insensitive, always stored in lower-case (for JMAP), sorted.
 	Size: number
 	TrainedJunk?: boolean | null  // If nil, no training done yet. Otherwise, true is trained as junk, false trained as nonjunk.
-	MsgPrefix?: string | null  // Typically holds received headers and/or header separator.
-	ParsedBuf?: string | null  // ParsedBuf message structure. Currently saved as JSON of message.Part because bstore cannot yet store recursive types. Created when first needed, and saved in the database. todo: once replaced with non-json storage, remove date fixup in ../message/part.go.
+	TrainedShared: boolean  // Whether the message is also trained in the shared junk filter, as indicated by TrainedJunk.
+	Checksum?: string | null  // SHA-256 of the contents of the message file, i.e. without MsgPrefix, and decrypted and decompressed. Set on delivery, for older messages when first scrubbed. For detecting corruption of message files on disk.
+	FileEncrypted: boolean  // Whether the message file is encrypted. Recorded instead of detected from the file, because messages can contain arbitrary data. Set on delivery, based on the configuration at that time.
+	FileCompressed: boolean  // Whether the message file is compressed. Recorded like FileEncrypted.
 }
 
 // MessageEnvelope is like message.Envelope, as used in message.Part, but including
@@ -392,6 +515,69 @@ export interface Attachment {
 	Part: Part
 }
 
+// EventStart is the first message sent on an SSE connection, giving the client
+// basic data to populate its UI. After this event, messages will follow quickly in
+// an EventViewMsgs event.
+export interface EventStart {
+	SSEID: number
+	LoginAddress: MessageAddress
+	Addresses?: MessageAddress[] | null
+	DomainAddressConfigs?: { [key: string]: DomainAddressConfig }  // ASCII domain to address config.
+	MailboxName: string
+	Mailboxes?: Mailbox[] | null
+	RejectsMailbox: string
+	Settings: Settings
+	Identities?: Identity[] | null  // Settings per From address for composing messages.
+	AccountPath: string  // If nonempty, the path on same host to webaccount interface.
+	Version: string
+}
+
+// DomainAddressConfig has the address (localpart) configuration for a domain, so
+// the webmail client can decide if an address matches the addresses of the
+// account.
+export interface DomainAddressConfig {
+	LocalpartCatchallSeparator: string  // Can be empty.
+	LocalpartCaseSensitive: boolean
+}
+
+// Identity holds settings for sending messages from one of the addresses of the
+// account, used by webmail when composing a message with the address in the From
+// header. Empty fields fall back to the account-wide settings.
+export interface Identity {
+	ID: number
+	Address: string  // Unicode user@domain.
+	FullName: string  // Display name in From header, instead of configured full name.
+	ReplyTo: string  // Address for Reply-To header, optional.
+	Signature: string  // Signature, instead of the signature in the account settings.
+}
+
+// EventViewErr indicates an error during a query for messages. The request is
+// aborted, no more request-related messages will be sent until the next request.
+export interface EventViewErr {
+	ViewID: number
+	RequestID: number
+	Err: string  // To be displayed in client.
+}
+
+// EventViewReset indicates that a request for the next set of messages in a few
+// could not be fulfilled, e.g. because the anchor message does not exist anymore.
+// The client should clear its list of messages. This can happen before
+// EventViewMsgs events are sent.
+export interface EventViewReset {
+	ViewID: number
+	RequestID: number
+}
+
+// EventViewMsgs contains messages for a view, possibly a continuation of an
+// earlier list of messages.
+export interface EventViewMsgs {
+	ViewID: number
+	RequestID: number
+	MessageItems?: (MessageItem[] | null)[] | null  // If empty, this was the last message for the request. If non-empty, a list of thread messages. Each with the first message being the reason this thread is included and can be used as AnchorID in followup requests. If the threading mode is "off" in the query, there will always be only a single message. If a thread is sent, all messages in the thread are sent, including those that don't match the query (e.g. from another mailbox). Threads can be displayed based on the ThreadParentIDs field, with possibly slightly different display based on field ThreadMissingLink.
+	ParsedMessage?: ParsedMessage | null  // If set, will match the target page.DestMessageID from the request.
+	ViewEnd: boolean  // If set, there are no more messages in this view at this moment. Messages can be added, typically via Change messages, e.g. for new deliveries.
+}
+
 // EventViewChanges contain one or more changes relevant for the client, either
 // with new mailbox total/unseen message counts, or messages added/removed/modified
 // (flags) for the current view.
@@ -477,6 +663,7 @@ export interface ChangeMailboxCounts {
 	Unread: number  // Messages without \Seen, excluding those with \Deleted, for JMAP.
 	Unseen: number  // Messages without \Seen, including those with \Deleted, for IMAP.
 	Size: number  // Number of bytes for all messages.
+	DeletedSize: number  // Number of bytes for messages with \Deleted flag, i.e. the storage that is reclaimed by expunging. For IMAP STATUS DELETED-STORAGE.
 }
 
 // ChangeMailboxSpecialUse has updated special-use flags for a mailbox.
@@ -575,45 +762,60 @@ export enum Quoting {
 	Top = "top",
 }
 
-// Localpart is a decoded local part of an email address, before the "@".
-// For quoted strings, values do not hold the double quote or escaping backslashes.
-// An empty string can be a valid localpart.
-// Localparts are in Unicode NFC.
-export type Localpart = string
+// RetentionAction is the action of a retention rule for old messages.
+export enum RetentionAction {
+	RetentionArchive = "archive",  // Move to Destination mailbox.
+	RetentionArchiveYear = "archiveyear",  // Move to a child mailbox of Destination named after the year the message was received, e.g. Archive/2024.
+	RetentionDelete = "delete",  // Remove message permanently.
+}
 
-export const structTypes: {[typename: string]: boolean} = {"Address":true,"Attachment":true,"ChangeMailboxAdd":true,"ChangeMailboxCounts":true,"ChangeMailboxKeywords":true,"ChangeMailboxRemove":true,"ChangeMailboxRename":true,"ChangeMailboxSpecialUse":true,"ChangeMsgAdd":true,"ChangeMsgFlags":true,"ChangeMsgRemove":true,"ChangeMsgThread":true,"ComposeMessage":true,"Domain":true,"DomainAddressConfig":true,"Envelope":true,"EventStart":true,"EventViewChanges":true,"EventViewErr":true,"EventViewMsgs":true,"EventViewReset":true,"File":true,"Filter":true,"Flags":true,"ForwardAttachments":true,"FromAddressSettings":true,"Mailbox":true,"Message":true,"MessageAddress":true,"MessageEnvelope":true,"MessageItem":true,"NotFilter":true,"Page":true,"ParsedMessage":true,"Part":true,"Query":true,"RecipientSecurity":true,"Request":true,"Ruleset":true,"Settings":true,"SpecialUse":true,"SubmitMessage":true}
-export const stringsTypes: {[typename: string]: boolean} = {"AttachmentType":true,"CSRFToken":true,"Localpart":true,"Quoting":true,"SecurityResult":true,"ThreadMode":true,"ViewMode":true}
+export const structTypes: {[typename: string]: boolean} = {"Address":true,"Attachment":true,"ChangeMailboxAdd":true,"ChangeMailboxCounts":true,"ChangeMailboxKeywords":true,"ChangeMailboxRemove":true,"ChangeMailboxRename":true,"ChangeMailboxSpecialUse":true,"ChangeMsgAdd":true,"ChangeMsgFlags":true,"ChangeMsgRemove":true,"ChangeMsgThread":true,"ComposeMessage":true,"Domain":true,"DomainAddressConfig":true,"Envelope":true,"EventStart":true,"EventViewChanges":true,"EventViewErr":true,"EventViewMsgs":true,"EventViewReset":true,"File":true,"Filter":true,"FilterRule":true,"Flags":true,"ForwardAttachments":true,"FromAddressSettings":true,"Identity":true,"Invite":true,"InviteAttendee":true,"LinkedAccount":true,"Mailbox":true,"Message":true,"MessageAddress":true,"MessageEnvelope":true,"MessageItem":true,"NotFilter":true,"PGPKey":true,"Page":true,"ParsedMessage":true,"Part":true,"PasskeyAssertion":true,"PasskeyRequestOptions":true,"Query":true,"RecipientSecurity":true,"Request":true,"Result":true,"RetentionRule":true,"Ruleset":true,"Settings":true,"SpecialUse":true,"SubmitMessage":true,"SubmitResult":true,"UnifiedMessage":true,"UnifiedPage":true,"Upload":true}
+export const stringsTypes: {[typename: string]: boolean} = {"AttachmentType":true,"CSRFToken":true,"Quoting":true,"RetentionAction":true,"SecurityResult":true,"ThreadMode":true,"ViewMode":true}
 export const intsTypes: {[typename: string]: boolean} = {"ModSeq":true,"UID":true,"Validation":true}
 export const types: TypenameMap = {
+	"PasskeyRequestOptions": {"Name":"PasskeyRequestOptions","Docs":"","Fields":[{"Name":"Challenge","Docs":"","Typewords":["string"]},{"Name":"RPID","Docs":"","Typewords":["string"]},{"Name":"Timeout","Docs":"","Typewords":["int32"]}]},
+	"PasskeyAssertion": {"Name":"PasskeyAssertion","Docs":"","Fields":[{"Name":"CredentialID","Docs":"","Typewords":["string"]},{"Name":"ClientDataJSON","Docs":"","Typewords":["string"]},{"Name":"AuthenticatorData","Docs":"","Typewords":["string"]},{"Name":"Signature","Docs":"","Typewords":["string"]},{"Name":"UserHandle","Docs":"","Typewords":["string"]}]},
 	"Request": {"Name":"Request","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"SSEID","Docs":"","Typewords":["int64"]},{"Name":"ViewID","Docs":"","Typewords":["int64"]},{"Name":"Cancel","Docs":"","Typewords":["bool"]},{"Name":"Query","Docs":"","Typewords":["Query"]},{"Name":"Page","Docs":"","Typewords":["Page"]}]},
 	"Query": {"Name":"Query","Docs":"","Fields":[{"Name":"OrderAsc","Docs":"","Typewords":["bool"]},{"Name":"Threading","Docs":"","Typewords":["ThreadMode"]},{"Name":"Filter","Docs":"","Typewords":["Filter"]},{"Name":"NotFilter","Docs":"","Typewords":["NotFilter"]}]},
 	"Filter": {"Name":"Filter","Docs":"","Fields":[{"Name":"MailboxID","Docs":"","Typewords":["int64"]},{"Name":"MailboxChildrenIncluded","Docs":"","Typewords":["bool"]},{"Name":"MailboxName","Docs":"","Typewords":["string"]},{"Name":"Words","Docs":"","Typewords":["[]","string"]},{"Name":"From","Docs":"","Typewords":["[]","string"]},{"Name":"To","Docs":"","Typewords":["[]","string"]},{"Name":"Oldest","Docs":"","Typewords":["nullable","timestamp"]},{"Name":"Newest","Docs":"","Typewords":["nullable","timestamp"]},{"Name":"Subject","Docs":"","Typewords":["[]","string"]},{"Name":"Attachments","Docs":"","Typewords":["AttachmentType"]},{"Name":"Labels","Docs":"","Typewords":["[]","string"]},{"Name":"Headers","Docs":"","Typewords":["[]","[]","string"]},{"Name":"SizeMin","Docs":"","Typewords":["int64"]},{"Name":"SizeMax","Docs":"","Typewords":["int64"]}]},
 	"NotFilter": {"Name":"NotFilter","Docs":"","Fields":[{"Name":"Words","Docs":"","Typewords":["[]","string"]},{"Name":"From","Docs":"","Typewords":["[]","string"]},{"Name":"To","Docs":"","Typewords":["[]","string"]},{"Name":"Subject","Docs":"","Typewords":["[]","string"]},{"Name":"Attachments","Docs":"","Typewords":["AttachmentType"]},{"Name":"Labels","Docs":"","Typewords":["[]","string"]}]},
 	"Page": {"Name":"Page","Docs":"","Fields":[{"Name":"AnchorMessageID","Docs":"","Typewords":["int64"]},{"Name":"Count","Docs":"","Typewords":["int32"]},{"Name":"DestMessageID","Docs":"","Typewords":["int64"]}]},
-	"ParsedMessage": {"Name":"ParsedMessage","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Part","Docs":"","Typewords":["Part"]},{"Name":"Headers","Docs":"","Typewords":["{}","[]","string"]},{"Name":"ViewMode","Docs":"","Typewords":["ViewMode"]},{"Name":"Texts","Docs":"","Typewords":["[]","string"]},{"Name":"HasHTML","Docs":"","Typewords":["bool"]},{"Name":"ListReplyAddress","Docs":"","Typewords":["nullable","MessageAddress"]}]},
+	"ParsedMessage": {"Name":"ParsedMessage","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Part","Docs":"","Typewords":["Part"]},{"Name":"Headers","Docs":"","Typewords":["{}","[]","string"]},{"Name":"ViewMode","Docs":"","Typewords":["ViewMode"]},{"Name":"Texts","Docs":"","Typewords":["[]","string"]},{"Name":"HasHTML","Docs":"","Typewords":["bool"]},{"Name":"ListReplyAddress","Docs":"","Typewords":["nullable","MessageAddress"]},{"Name":"Invite","Docs":"","Typewords":["nullable","Invite"]}]},
 	"Part": {"Name":"Part","Docs":"","Fields":[{"Name":"BoundaryOffset","Docs":"","Typewords":["int64"]},{"Name":"HeaderOffset","Docs":"","Typewords":["int64"]},{"Name":"BodyOffset","Docs":"","Typewords":["int64"]},{"Name":"EndOffset","Docs":"","Typewords":["int64"]},{"Name":"RawLineCount","Docs":"","Typewords":["int64"]},{"Name":"DecodedSize","Docs":"","Typewords":["int64"]},{"Name":"MediaType","Docs":"","Typewords":["string"]},{"Name":"MediaSubType","Docs":"","Typewords":["string"]},{"Name":"ContentTypeParams","Docs":"","Typewords":["{}","string"]},{"Name":"ContentID","Docs":"","Typewords":["string"]},{"Name":"ContentDescription","Docs":"","Typewords":["string"]},{"Name":"ContentTransferEncoding","Docs":"","Typewords":["string"]},{"Name":"Envelope","Docs":"","Typewords":["nullable","Envelope"]},{"Name":"Parts","Docs":"","Typewords":["[]","Part"]},{"Name":"Message","Docs":"","Typewords":["nullable","Part"]}]},
 	"Envelope": {"Name":"Envelope","Docs":"","Fields":[{"Name":"Date","Docs":"","Typewords":["timestamp"]},{"Name":"Subject","Docs":"","Typewords":["string"]},{"Name":"From","Docs":"","Typewords":["[]","Address"]},{"Name":"Sender","Docs":"","Typewords":["[]","Address"]},{"Name":"ReplyTo","Docs":"","Typewords":["[]","Address"]},{"Name":"To","Docs":"","Typewords":["[]","Address"]},{"Name":"CC","Docs":"","Typewords":["[]","Address"]},{"Name":"BCC","Docs":"","Typewords":["[]","Address"]},{"Name":"InReplyTo","Docs":"","Typewords":["string"]},{"Name":"MessageID","Docs":"","Typewords":["string"]}]},
 	"Address": {"Name":"Address","Docs":"","Fields":[{"Name":"Name","Docs":"","Typewords":["string"]},{"Name":"User","Docs":"","Typewords":["string"]},{"Name":"Host","Docs":"","Typewords":["string"]}]},
 	"MessageAddress": {"Name":"MessageAddress","Docs":"","Fields":[{"Name":"Name","Docs":"","Typewords":["string"]},{"Name":"User","Docs":"","Typewords":["string"]},{"Name":"Domain","Docs":"","Typewords":["Domain"]}]},
 	"Domain": {"Name":"Domain","Docs":"","Fields":[{"Name":"ASCII","Docs":"","Typewords":["string"]},{"Name":"Unicode","Docs":"","Typewords":["string"]}]},
+	"Invite": {"Name":"Invite","Docs":"","Fields":[{"Name":"Path","Docs":"","Typewords":["[]","int32"]},{"Name":"Method","Docs":"","Typewords":["string"]},{"Name":"UID","Docs":"","Typewords":["string"]},{"Name":"Summary","Docs":"","Typewords":["string"]},{"Name":"Location","Docs":"","Typewords":["string"]},{"Name":"Description","Docs":"","Typewords":["string"]},{"Name":"Start","Docs":"","Typewords":["timestamp"]},{"Name":"End","Docs":"","Typewords":["timestamp"]},{"Name":"AllDay","Docs":"","Typewords":["bool"]},{"Name":"Organizer","Docs":"","Typewords":["InviteAttendee"]},{"Name":"Attendees","Docs":"","Typewords":["[]","InviteAttendee"]}]},
+	"InviteAttendee": {"Name":"InviteAttendee","Docs":"","Fields":[{"Name":"Name","Docs":"","Typewords":["string"]},{"Name":"Email","Docs":"","Typewords":["string"]},{"Name":"Status","Docs":"","Typewords":["string"]}]},
 	"FromAddressSettings": {"Name":"FromAddressSettings","Docs":"","Fields":[{"Name":"FromAddress","Docs":"","Typewords":["string"]},{"Name":"ViewMode","Docs":"","Typewords":["ViewMode"]}]},
-	"ComposeMessage": {"Name":"ComposeMessage","Docs":"","Fields":[{"Name":"From","Docs":"","Typewords":["string"]},{"Name":"To","Docs":"","Typewords":["[]","string"]},{"Name":"Cc","Docs":"","Typewords":["[]","string"]},{"Name":"Bcc","Docs":"","Typewords":["[]","string"]},{"Name":"ReplyTo","Docs":"","Typewords":["string"]},{"Name":"Subject","Docs":"","Typewords":["string"]},{"Name":"TextBody","Docs":"","Typewords":["string"]},{"Name":"ResponseMessageID","Docs":"","Typewords":["int64"]},{"Name":"DraftMessageID","Docs":"","Typewords":["int64"]}]},
-	"SubmitMessage": {"Name":"SubmitMessage","Docs":"","Fields":[{"Name":"From","Docs":"","Typewords":["string"]},{"Name":"To","Docs":"","Typewords":["[]","string"]},{"Name":"Cc","Docs":"","Typewords":["[]","string"]},{"Name":"Bcc","Docs":"","Typewords":["[]","string"]},{"Name":"ReplyTo","Docs":"","Typewords":["string"]},{"Name":"Subject","Docs":"","Typewords":["string"]},{"Name":"TextBody","Docs":"","Typewords":["string"]},{"Name":"Attachments","Docs":"","Typewords":["[]","File"]},{"Name":"ForwardAttachments","Docs":"","Typewords":["ForwardAttachments"]},{"Name":"IsForward","Docs":"","Typewords":["bool"]},{"Name":"ResponseMessageID","Docs":"","Typewords":["int64"]},{"Name":"UserAgent","Docs":"","Typewords":["string"]},{"Name":"RequireTLS","Docs":"","Typewords":["nullable","bool"]},{"Name":"FutureRelease","Docs":"","Typewords":["nullable","timestamp"]},{"Name":"ArchiveThread","Docs":"","Typewords":["bool"]},{"Name":"DraftMessageID","Docs":"","Typewords":["int64"]}]},
+	"ComposeMessage": {"Name":"ComposeMessage","Docs":"","Fields":[{"Name":"From","Docs":"","Typewords":["string"]},{"Name":"To","Docs":"","Typewords":["[]","string"]},{"Name":"Cc","Docs":"","Typewords":["[]","string"]},{"Name":"Bcc","Docs":"","Typewords":["[]","string"]},{"Name":"ReplyTo","Docs":"","Typewords":["string"]},{"Name":"Subject","Docs":"","Typewords":["string"]},{"Name":"TextBody","Docs":"","Typewords":["string"]},{"Name":"HTMLBody","Docs":"","Typewords":["string"]},{"Name":"ResponseMessageID","Docs":"","Typewords":["int64"]},{"Name":"DraftMessageID","Docs":"","Typewords":["int64"]},{"Name":"UploadIDs","Docs":"","Typewords":["[]","int64"]}]},
+	"SubmitMessage": {"Name":"SubmitMessage","Docs":"","Fields":[{"Name":"From","Docs":"","Typewords":["string"]},{"Name":"To","Docs":"","Typewords":["[]","string"]},{"Name":"Cc","Docs":"","Typewords":["[]","string"]},{"Name":"Bcc","Docs":"","Typewords":["[]","string"]},{"Name":"ReplyTo","Docs":"","Typewords":["string"]},{"Name":"Subject","Docs":"","Typewords":["string"]},{"Name":"TextBody","Docs":"","Typewords":["string"]},{"Name":"Attachments","Docs":"","Typewords":["[]","File"]},{"Name":"ForwardAttachments","Docs":"","Typewords":["ForwardAttachments"]},{"Name":"IsForward","Docs":"","Typewords":["bool"]},{"Name":"ResponseMessageID","Docs":"","Typewords":["int64"]},{"Name":"UserAgent","Docs":"","Typewords":["string"]},{"Name":"RequireTLS","Docs":"","Typewords":["nullable","bool"]},{"Name":"FutureRelease","Docs":"","Typewords":["nullable","timestamp"]},{"Name":"ArchiveThread","Docs":"","Typewords":["bool"]},{"Name":"DraftMessageID","Docs":"","Typewords":["int64"]},{"Name":"UploadIDs","Docs":"","Typewords":["[]","int64"]},{"Name":"HTMLBody","Docs":"","Typewords":["string"]},{"Name":"PGPEncrypted","Docs":"","Typewords":["string"]},{"Name":"Account","Docs":"","Typewords":["string"]}]},
 	"File": {"Name":"File","Docs":"","Fields":[{"Name":"Filename","Docs":"","Typewords":["string"]},{"Name":"DataURI","Docs":"","Typewords":["string"]}]},
 	"ForwardAttachments": {"Name":"ForwardAttachments","Docs":"","Fields":[{"Name":"MessageID","Docs":"","Typewords":["int64"]},{"Name":"Paths","Docs":"","Typewords":["[]","[]","int32"]}]},
-	"Mailbox": {"Name":"Mailbox","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Name","Docs":"","Typewords":["string"]},{"Name":"UIDValidity","Docs":"","Typewords":["uint32"]},{"Name":"UIDNext","Docs":"","Typewords":["UID"]},{"Name":"Archive","Docs":"","Typewords":["bool"]},{"Name":"Draft","Docs":"","Typewords":["bool"]},{"Name":"Junk","Docs":"","Typewords":["bool"]},{"Name":"Sent","Docs":"","Typewords":["bool"]},{"Name":"Trash","Docs":"","Typewords":["bool"]},{"Name":"Keywords","Docs":"","Typewords":["[]","string"]},{"Name":"HaveCounts","Docs":"","Typewords":["bool"]},{"Name":"Total","Docs":"","Typewords":["int64"]},{"Name":"Deleted","Docs":"","Typewords":["int64"]},{"Name":"Unread","Docs":"","Typewords":["int64"]},{"Name":"Unseen","Docs":"","Typewords":["int64"]},{"Name":"Size","Docs":"","Typewords":["int64"]}]},
+	"SubmitResult": {"Name":"SubmitResult","Docs":"","Fields":[{"Name":"UndoUntil","Docs":"","Typewords":["nullable","timestamp"]},{"Name":"QueueMsgIDs","Docs":"","Typewords":["[]","int64"]},{"Name":"SentMessageID","Docs":"","Typewords":["int64"]},{"Name":"Account","Docs":"","Typewords":["string"]}]},
+	"Upload": {"Name":"Upload","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Created","Docs":"","Typewords":["timestamp"]},{"Name":"DraftMessageID","Docs":"","Typewords":["int64"]},{"Name":"Filename","Docs":"","Typewords":["string"]},{"Name":"ContentType","Docs":"","Typewords":["string"]},{"Name":"ContentID","Docs":"","Typewords":["string"]},{"Name":"Size","Docs":"","Typewords":["int64"]},{"Name":"Received","Docs":"","Typewords":["int64"]}]},
+	"Mailbox": {"Name":"Mailbox","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Name","Docs":"","Typewords":["string"]},{"Name":"UIDValidity","Docs":"","Typewords":["uint32"]},{"Name":"UIDNext","Docs":"","Typewords":["UID"]},{"Name":"Archive","Docs":"","Typewords":["bool"]},{"Name":"Draft","Docs":"","Typewords":["bool"]},{"Name":"Junk","Docs":"","Typewords":["bool"]},{"Name":"Sent","Docs":"","Typewords":["bool"]},{"Name":"Trash","Docs":"","Typewords":["bool"]},{"Name":"Keywords","Docs":"","Typewords":["[]","string"]},{"Name":"HaveCounts","Docs":"","Typewords":["bool"]},{"Name":"Total","Docs":"","Typewords":["int64"]},{"Name":"Deleted","Docs":"","Typewords":["int64"]},{"Name":"Unread","Docs":"","Typewords":["int64"]},{"Name":"Unseen","Docs":"","Typewords":["int64"]},{"Name":"Size","Docs":"","Typewords":["int64"]},{"Name":"DeletedSize","Docs":"","Typewords":["int64"]}]},
 	"RecipientSecurity": {"Name":"RecipientSecurity","Docs":"","Fields":[{"Name":"STARTTLS","Docs":"","Typewords":["SecurityResult"]},{"Name":"MTASTS","Docs":"","Typewords":["SecurityResult"]},{"Name":"DNSSEC","Docs":"","Typewords":["SecurityResult"]},{"Name":"DANE","Docs":"","Typewords":["SecurityResult"]},{"Name":"RequireTLS","Docs":"","Typewords":["SecurityResult"]}]},
-	"Settings": {"Name":"Settings","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["uint8"]},{"Name":"Signature","Docs":"","Typewords":["string"]},{"Name":"Quoting","Docs":"","Typewords":["Quoting"]},{"Name":"ShowAddressSecurity","Docs":"","Typewords":["bool"]}]},
+	"Settings": {"Name":"Settings","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["uint8"]},{"Name":"Signature","Docs":"","Typewords":["string"]},{"Name":"Quoting","Docs":"","Typewords":["Quoting"]},{"Name":"ShowAddressSecurity","Docs":"","Typewords":["bool"]},{"Name":"SendUndoDelay","Docs":"","Typewords":["int32"]},{"Name":"Language","Docs":"","Typewords":["string"]}]},
 	"Ruleset": {"Name":"Ruleset","Docs":"","Fields":[{"Name":"SMTPMailFromRegexp","Docs":"","Typewords":["string"]},{"Name":"MsgFromRegexp","Docs":"","Typewords":["string"]},{"Name":"VerifiedDomain","Docs":"","Typewords":["string"]},{"Name":"HeadersRegexp","Docs":"","Typewords":["{}","string"]},{"Name":"IsForward","Docs":"","Typewords":["bool"]},{"Name":"ListAllowDomain","Docs":"","Typewords":["string"]},{"Name":"AcceptRejectsToMailbox","Docs":"","Typewords":["string"]},{"Name":"Mailbox","Docs":"","Typewords":["string"]},{"Name":"Comment","Docs":"","Typewords":["string"]},{"Name":"VerifiedDNSDomain","Docs":"","Typewords":["Domain"]},{"Name":"ListAllowDNSDomain","Docs":"","Typewords":["Domain"]}]},
-	"EventStart": {"Name":"EventStart","Docs":"","Fields":[{"Name":"SSEID","Docs":"","Typewords":["int64"]},{"Name":"LoginAddress","Docs":"","Typewords":["MessageAddress"]},{"Name":"Addresses","Docs":"","Typewords":["[]","MessageAddress"]},{"Name":"DomainAddressConfigs","Docs":"","Typewords":["{}","DomainAddressConfig"]},{"Name":"MailboxName","Docs":"","Typewords":["string"]},{"Name":"Mailboxes","Docs":"","Typewords":["[]","Mailbox"]},{"Name":"RejectsMailbox","Docs":"","Typewords":["string"]},{"Name":"Settings","Docs":"","Typewords":["Settings"]},{"Name":"AccountPath","Docs":"","Typewords":["string"]},{"Name":"Version","Docs":"","Typewords":["string"]}]},
+	"FilterRule": {"Name":"FilterRule","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Position","Docs":"","Typewords":["int32"]},{"Name":"Name","Docs":"","Typewords":["string"]},{"Name":"Disabled","Docs":"","Typewords":["bool"]},{"Name":"From","Docs":"","Typewords":["string"]},{"Name":"To","Docs":"","Typewords":["string"]},{"Name":"Subject","Docs":"","Typewords":["string"]},{"Name":"HeaderName","Docs":"","Typewords":["string"]},{"Name":"HeaderValue","Docs":"","Typewords":["string"]},{"Name":"SizeMin","Docs":"","Typewords":["int64"]},{"Name":"SizeMax","Docs":"","Typewords":["int64"]},{"Name":"Mailbox","Docs":"","Typewords":["string"]},{"Name":"Seen","Docs":"","Typewords":["bool"]},{"Name":"Flagged","Docs":"","Typewords":["bool"]},{"Name":"Keywords","Docs":"","Typewords":["[]","string"]},{"Name":"ForwardTo","Docs":"","Typewords":["string"]},{"Name":"Discard","Docs":"","Typewords":["bool"]}]},
+	"RetentionRule": {"Name":"RetentionRule","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Mailbox","Docs":"","Typewords":["string"]},{"Name":"AgeDays","Docs":"","Typewords":["int32"]},{"Name":"Action","Docs":"","Typewords":["RetentionAction"]},{"Name":"Destination","Docs":"","Typewords":["string"]},{"Name":"Disabled","Docs":"","Typewords":["bool"]},{"Name":"DryRun","Docs":"","Typewords":["bool"]},{"Name":"From","Docs":"","Typewords":["string"]},{"Name":"Subject","Docs":"","Typewords":["string"]},{"Name":"KeepFlagged","Docs":"","Typewords":["bool"]},{"Name":"KeepUnread","Docs":"","Typewords":["bool"]},{"Name":"LastRun","Docs":"","Typewords":["timestamp"]},{"Name":"LastMatched","Docs":"","Typewords":["int32"]},{"Name":"LastError","Docs":"","Typewords":["string"]}]},
+	"Result": {"Name":"Result","Docs":"","Fields":[{"Name":"Matched","Docs":"","Typewords":["int32"]},{"Name":"Size","Docs":"","Typewords":["int64"]}]},
+	"PGPKey": {"Name":"PGPKey","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Created","Docs":"","Typewords":["timestamp"]},{"Name":"Address","Docs":"","Typewords":["string"]},{"Name":"Fingerprint","Docs":"","Typewords":["string"]},{"Name":"UserIDs","Docs":"","Typewords":["[]","string"]},{"Name":"PublicKey","Docs":"","Typewords":["string"]},{"Name":"Own","Docs":"","Typewords":["bool"]},{"Name":"Autocrypt","Docs":"","Typewords":["bool"]},{"Name":"WKDPublish","Docs":"","Typewords":["bool"]},{"Name":"Source","Docs":"","Typewords":["string"]},{"Name":"PreferEncrypt","Docs":"","Typewords":["bool"]},{"Name":"AutocryptTimestamp","Docs":"","Typewords":["timestamp"]}]},
+	"LinkedAccount": {"Name":"LinkedAccount","Docs":"","Fields":[{"Name":"LinkID","Docs":"","Typewords":["int64"]},{"Name":"Account","Docs":"","Typewords":["string"]},{"Name":"LoginAddress","Docs":"","Typewords":["string"]},{"Name":"Addresses","Docs":"","Typewords":["[]","MessageAddress"]},{"Name":"Active","Docs":"","Typewords":["bool"]},{"Name":"Unread","Docs":"","Typewords":["int64"]},{"Name":"Mailboxes","Docs":"","Typewords":["[]","Mailbox"]}]},
+	"UnifiedPage": {"Name":"UnifiedPage","Docs":"","Fields":[{"Name":"AnchorReceived","Docs":"","Typewords":["timestamp"]},{"Name":"AnchorAccount","Docs":"","Typewords":["string"]},{"Name":"AnchorMessageID","Docs":"","Typewords":["int64"]},{"Name":"Count","Docs":"","Typewords":["int32"]}]},
+	"UnifiedMessage": {"Name":"UnifiedMessage","Docs":"","Fields":[{"Name":"Account","Docs":"","Typewords":["string"]},{"Name":"MessageItem","Docs":"","Typewords":["MessageItem"]}]},
+	"MessageItem": {"Name":"MessageItem","Docs":"","Fields":[{"Name":"Message","Docs":"","Typewords":["Message"]},{"Name":"Envelope","Docs":"","Typewords":["MessageEnvelope"]},{"Name":"Attachments","Docs":"","Typewords":["[]","Attachment"]},{"Name":"IsSigned","Docs":"","Typewords":["bool"]},{"Name":"IsEncrypted","Docs":"","Typewords":["bool"]},{"Name":"FirstLine","Docs":"","Typewords":["string"]},{"Name":"MatchQuery","Docs":"","Typewords":["bool"]}]},
+	"Message": {"Name":"Message","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"UID","Docs":"","Typewords":["UID"]},{"Name":"MailboxID","Docs":"","Typewords":["int64"]},{"Name":"ModSeq","Docs":"","Typewords":["ModSeq"]},{"Name":"CreateSeq","Docs":"","Typewords":["ModSeq"]},{"Name":"Expunged","Docs":"","Typewords":["bool"]},{"Name":"IsReject","Docs":"","Typewords":["bool"]},{"Name":"IsForward","Docs":"","Typewords":["bool"]},{"Name":"MailboxOrigID","Docs":"","Typewords":["int64"]},{"Name":"MailboxDestinedID","Docs":"","Typewords":["int64"]},{"Name":"Received","Docs":"","Typewords":["timestamp"]},{"Name":"RemoteIP","Docs":"","Typewords":["string"]},{"Name":"RemoteIPMasked1","Docs":"","Typewords":["string"]},{"Name":"RemoteIPMasked2","Docs":"","Typewords":["string"]},{"Name":"RemoteIPMasked3","Docs":"","Typewords":["string"]},{"Name":"EHLODomain","Docs":"","Typewords":["string"]},{"Name":"MailFromDomain","Docs":"","Typewords":["string"]},{"Name":"MsgFromDomain","Docs":"","Typewords":["string"]},{"Name":"MsgFromOrgDomain","Docs":"","Typewords":["string"]},{"Name":"EHLOValidated","Docs":"","Typewords":["bool"]},{"Name":"MailFromValidated","Docs":"","Typewords":["bool"]},{"Name":"MsgFromValidated","Docs":"","Typewords":["bool"]},{"Name":"EHLOValidation","Docs":"","Typewords":["Validation"]},{"Name":"MailFromValidation","Docs":"","Typewords":["Validation"]},{"Name":"MsgFromValidation","Docs":"","Typewords":["Validation"]},{"Name":"DKIMDomains","Docs":"","Typewords":["[]","string"]},{"Name":"OrigEHLODomain","Docs":"","Typewords":["string"]},{"Name":"OrigDKIMDomains","Docs":"","Typewords":["[]","string"]},{"Name":"MessageID","Docs":"","Typewords":["string"]},{"Name":"SubjectBase","Docs":"","Typewords":["string"]},{"Name":"MessageHash","Docs":"","Typewords":["nullable","string"]},{"Name":"ThreadID","Docs":"","Typewords":["int64"]},{"Name":"ThreadParentIDs","Docs":"","Typewords":["[]","int64"]},{"Name":"ThreadMissingLink","Docs":"","Typewords":["bool"]},{"Name":"ThreadMuted","Docs":"","Typewords":["bool"]},{"Name":"ThreadCollapsed","Docs":"","Typewords":["bool"]},{"Name":"IsMailingList","Docs":"","Typewords":["bool"]},{"Name":"DSN","Docs":"","Typewords":["bool"]},{"Name":"ReceivedTLSVersion","Docs":"","Typewords":["uint16"]},{"Name":"ReceivedTLSCipherSuite","Docs":"","Typewords":["uint16"]},{"Name":"ReceivedRequireTLS","Docs":"","Typewords":["bool"]},{"Name":"ReceivedTLSFingerprint","Docs":"","Typewords":["string"]},{"Name":"Seen","Docs":"","Typewords":["bool"]},{"Name":"Answered","Docs":"","Typewords":["bool"]},{"Name":"Flagged","Docs":"","Typewords":["bool"]},{"Name":"Forwarded","Docs":"","Typewords":["bool"]},{"Name":"Junk","Docs":"","Typewords":["bool"]},{"Name":"Notjunk","Docs":"","Typewords":["bool"]},{"Name":"Deleted","Docs":"","Typewords":["bool"]},{"Name":"Draft","Docs":"","Typewords":["bool"]},{"Name":"Phishing","Docs":"","Typewords":["bool"]},{"Name":"MDNSent","Docs":"","Typewords":["bool"]},{"Name":"Keywords","Docs":"","Typewords":["[]","string"]},{"Name":"Size","Docs":"","Typewords":["int64"]},{"Name":"TrainedJunk","Docs":"","Typewords":["nullable","bool"]},{"Name":"TrainedShared","Docs":"","Typewords":["bool"]},{"Name":"Checksum","Docs":"","Typewords":["nullable","string"]},{"Name":"FileEncrypted","Docs":"","Typewords":["bool"]},{"Name":"FileCompressed","Docs":"","Typewords":["bool"]}]},
+	"MessageEnvelope": {"Name":"MessageEnvelope","Docs":"","Fields":[{"Name":"Date","Docs":"","Typewords":["timestamp"]},{"Name":"Subject","Docs":"","Typewords":["string"]},{"Name":"From","Docs":"","Typewords":["[]","MessageAddress"]},{"Name":"Sender","Docs":"","Typewords":["[]","MessageAddress"]},{"Name":"ReplyTo","Docs":"","Typewords":["[]","MessageAddress"]},{"Name":"To","Docs":"","Typewords":["[]","MessageAddress"]},{"Name":"CC","Docs":"","Typewords":["[]","MessageAddress"]},{"Name":"BCC","Docs":"","Typewords":["[]","MessageAddress"]},{"Name":"InReplyTo","Docs":"","Typewords":["string"]},{"Name":"MessageID","Docs":"","Typewords":["string"]}]},
+	"Attachment": {"Name":"Attachment","Docs":"","Fields":[{"Name":"Path","Docs":"","Typewords":["[]","int32"]},{"Name":"Filename","Docs":"","Typewords":["string"]},{"Name":"Part","Docs":"","Typewords":["Part"]}]},
+	"EventStart": {"Name":"EventStart","Docs":"","Fields":[{"Name":"SSEID","Docs":"","Typewords":["int64"]},{"Name":"LoginAddress","Docs":"","Typewords":["MessageAddress"]},{"Name":"Addresses","Docs":"","Typewords":["[]","MessageAddress"]},{"Name":"DomainAddressConfigs","Docs":"","Typewords":["{}","DomainAddressConfig"]},{"Name":"MailboxName","Docs":"","Typewords":["string"]},{"Name":"Mailboxes","Docs":"","Typewords":["[]","Mailbox"]},{"Name":"RejectsMailbox","Docs":"","Typewords":["string"]},{"Name":"Settings","Docs":"","Typewords":["Settings"]},{"Name":"Identities","Docs":"","Typewords":["[]","Identity"]},{"Name":"AccountPath","Docs":"","Typewords":["string"]},{"Name":"Version","Docs":"","Typewords":["string"]}]},
 	"DomainAddressConfig": {"Name":"DomainAddressConfig","Docs":"","Fields":[{"Name":"LocalpartCatchallSeparator","Docs":"","Typewords":["string"]},{"Name":"LocalpartCaseSensitive","Docs":"","Typewords":["bool"]}]},
+	"Identity": {"Name":"Identity","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"Address","Docs":"","Typewords":["string"]},{"Name":"FullName","Docs":"","Typewords":["string"]},{"Name":"ReplyTo","Docs":"","Typewords":["string"]},{"Name":"Signature","Docs":"","Typewords":["string"]}]},
 	"EventViewErr": {"Name":"EventViewErr","Docs":"","Fields":[{"Name":"ViewID","Docs":"","Typewords":["int64"]},{"Name":"RequestID","Docs":"","Typewords":["int64"]},{"Name":"Err","Docs":"","Typewords":["string"]}]},
 	"EventViewReset": {"Name":"EventViewReset","Docs":"","Fields":[{"Name":"ViewID","Docs":"","Typewords":["int64"]},{"Name":"RequestID","Docs":"","Typewords":["int64"]}]},
 	"EventViewMsgs": {"Name":"EventViewMsgs","Docs":"","Fields":[{"Name":"ViewID","Docs":"","Typewords":["int64"]},{"Name":"RequestID","Docs":"","Typewords":["int64"]},{"Name":"MessageItems","Docs":"","Typewords":["[]","[]","MessageItem"]},{"Name":"ParsedMessage","Docs":"","Typewords":["nullable","ParsedMessage"]},{"Name":"ViewEnd","Docs":"","Typewords":["bool"]}]},
-	"MessageItem": {"Name":"MessageItem","Docs":"","Fields":[{"Name":"Message","Docs":"","Typewords":["Message"]},{"Name":"Envelope","Docs":"","Typewords":["MessageEnvelope"]},{"Name":"Attachments","Docs":"","Typewords":["[]","Attachment"]},{"Name":"IsSigned","Docs":"","Typewords":["bool"]},{"Name":"IsEncrypted","Docs":"","Typewords":["bool"]},{"Name":"FirstLine","Docs":"","Typewords":["string"]},{"Name":"MatchQuery","Docs":"","Typewords":["bool"]}]},
-	"Message": {"Name":"Message","Docs":"","Fields":[{"Name":"ID","Docs":"","Typewords":["int64"]},{"Name":"UID","Docs":"","Typewords":["UID"]},{"Name":"MailboxID","Docs":"","Typewords":["int64"]},{"Name":"ModSeq","Docs":"","Typewords":["ModSeq"]},{"Name":"CreateSeq","Docs":"","Typewords":["ModSeq"]},{"Name":"Expunged","Docs":"","Typewords":["bool"]},{"Name":"IsReject","Docs":"","Typewords":["bool"]},{"Name":"IsForward","Docs":"","Typewords":["bool"]},{"Name":"MailboxOrigID","Docs":"","Typewords":["int64"]},{"Name":"MailboxDestinedID","Docs":"","Typewords":["int64"]},{"Name":"Received","Docs":"","Typewords":["timestamp"]},{"Name":"RemoteIP","Docs":"","Typewords":["string"]},{"Name":"RemoteIPMasked1","Docs":"","Typewords":["string"]},{"Name":"RemoteIPMasked2","Docs":"","Typewords":["string"]},{"Name":"RemoteIPMasked3","Docs":"","Typewords":["string"]},{"Name":"EHLODomain","Docs":"","Typewords":["string"]},{"Name":"MailFrom","Docs":"","Typewords":["string"]},{"Name":"MailFromLocalpart","Docs":"","Typewords":["Localpart"]},{"Name":"MailFromDomain","Docs":"","Typewords":["string"]},{"Name":"RcptToLocalpart","Docs":"","Typewords":["Localpart"]},{"Name":"RcptToDomain","Docs":"","Typewords":["string"]},{"Name":"MsgFromLocalpart","Docs":"","Typewords":["Localpart"]},{"Name":"MsgFromDomain","Docs":"","Typewords":["string"]},{"Name":"MsgFromOrgDomain","Docs":"","Typewords":["string"]},{"Name":"EHLOValidated","Docs":"","Typewords":["bool"]},{"Name":"MailFromValidated","Docs":"","Typewords":["bool"]},{"Name":"MsgFromValidated","Docs":"","Typewords":["bool"]},{"Name":"EHLOValidation","Docs":"","Typewords":["Validation"]},{"Name":"MailFromValidation","Docs":"","Typewords":["Validation"]},{"Name":"MsgFromValidation","Docs":"","Typewords":["Validation"]},{"Name":"DKIMDomains","Docs":"","Typewords":["[]","string"]},{"Name":"OrigEHLODomain","Docs":"","Typewords":["string"]},{"Name":"OrigDKIMDomains","Docs":"","Typewords":["[]","string"]},{"Name":"MessageID","Docs":"","Typewords":["string"]},{"Name":"SubjectBase","Docs":"","Typewords":["string"]},{"Name":"MessageHash","Docs":"","Typewords":["nullable","string"]},{"Name":"ThreadID","Docs":"","Typewords":["int64"]},{"Name":"ThreadParentIDs","Docs":"","Typewords":["[]","int64"]},{"Name":"ThreadMissingLink","Docs":"","Typewords":["bool"]},{"Name":"ThreadMuted","Docs":"","Typewords":["bool"]},{"Name":"ThreadCollapsed","Docs":"","Typewords":["bool"]},{"Name":"IsMailingList","Docs":"","Typewords":["bool"]},{"Name":"DSN","Docs":"","Typewords":["bool"]},{"Name":"ReceivedTLSVersion","Docs":"","Typewords":["uint16"]},{"Name":"ReceivedTLSCipherSuite","Docs":"","Typewords":["uint16"]},{"Name":"ReceivedRequireTLS","Docs":"","Typewords":["bool"]},{"Name":"Seen","Docs":"","Typewords":["bool"]},{"Name":"Answered","Docs":"","Typewords":["bool"]},{"Name":"Flagged","Docs":"","Typewords":["bool"]},{"Name":"Forwarded","Docs":"","Typewords":["bool"]},{"Name":"Junk","Docs":"","Typewords":["bool"]},{"Name":"Notjunk","Docs":"","Typewords":["bool"]},{"Name":"Deleted","Docs":"","Typewords":["bool"]},{"Name":"Draft","Docs":"","Typewords":["bool"]},{"Name":"Phishing","Docs":"","Typewords":["bool"]},{"Name":"MDNSent","Docs":"","Typewords":["bool"]},{"Name":"Keywords","Docs":"","Typewords":["[]","string"]},{"Name":"Size","Docs":"","Typewords":["int64"]},{"Name":"TrainedJunk","Docs":"","Typewords":["nullable","bool"]},{"Name":"MsgPrefix","Docs":"","Typewords":["nullable","string"]},{"Name":"ParsedBuf","Docs":"","Typewords":["nullable","string"]}]},
-	"MessageEnvelope": {"Name":"MessageEnvelope","Docs":"","Fields":[{"Name":"Date","Docs":"","Typewords":["timestamp"]},{"Name":"Subject","Docs":"","Typewords":["string"]},{"Name":"From","Docs":"","Typewords":["[]","MessageAddress"]},{"Name":"Sender","Docs":"","Typewords":["[]","MessageAddress"]},{"Name":"ReplyTo","Docs":"","Typewords":["[]","MessageAddress"]},{"Name":"To","Docs":"","Typewords":["[]","MessageAddress"]},{"Name":"CC","Docs":"","Typewords":["[]","MessageAddress"]},{"Name":"BCC","Docs":"","Typewords":["[]","MessageAddress"]},{"Name":"InReplyTo","Docs":"","Typewords":["string"]},{"Name":"MessageID","Docs":"","Typewords":["string"]}]},
-	"Attachment": {"Name":"Attachment","Docs":"","Fields":[{"Name":"Path","Docs":"","Typewords":["[]","int32"]},{"Name":"Filename","Docs":"","Typewords":["string"]},{"Name":"Part","Docs":"","Typewords":["Part"]}]},
 	"EventViewChanges": {"Name":"EventViewChanges","Docs":"","Fields":[{"Name":"ViewID","Docs":"","Typewords":["int64"]},{"Name":"Changes","Docs":"","Typewords":["[]","[]","any"]}]},
 	"ChangeMsgAdd": {"Name":"ChangeMsgAdd","Docs":"","Fields":[{"Name":"MailboxID","Docs":"","Typewords":["int64"]},{"Name":"UID","Docs":"","Typewords":["UID"]},{"Name":"ModSeq","Docs":"","Typewords":["ModSeq"]},{"Name":"Flags","Docs":"","Typewords":["Flags"]},{"Name":"Keywords","Docs":"","Typewords":["[]","string"]},{"Name":"MessageItems","Docs":"","Typewords":["[]","MessageItem"]}]},
 	"Flags": {"Name":"Flags","Docs":"","Fields":[{"Name":"Seen","Docs":"","Typewords":["bool"]},{"Name":"Answered","Docs":"","Typewords":["bool"]},{"Name":"Flagged","Docs":"","Typewords":["bool"]},{"Name":"Forwarded","Docs":"","Typewords":["bool"]},{"Name":"Junk","Docs":"","Typewords":["bool"]},{"Name":"Notjunk","Docs":"","Typewords":["bool"]},{"Name":"Deleted","Docs":"","Typewords":["bool"]},{"Name":"Draft","Docs":"","Typewords":["bool"]},{"Name":"Phishing","Docs":"","Typewords":["bool"]},{"Name":"MDNSent","Docs":"","Typewords":["bool"]}]},
@@ -623,7 +825,7 @@ export const types: TypenameMap = {
 	"ChangeMailboxRemove": {"Name":"ChangeMailboxRemove","Docs":"","Fields":[{"Name":"MailboxID","Docs":"","Typewords":["int64"]},{"Name":"Name","Docs":"","Typewords":["string"]}]},
 	"ChangeMailboxAdd": {"Name":"ChangeMailboxAdd","Docs":"","Fields":[{"Name":"Mailbox","Docs":"","Typewords":["Mailbox"]}]},
 	"ChangeMailboxRename": {"Name":"ChangeMailboxRename","Docs":"","Fields":[{"Name":"MailboxID","Docs":"","Typewords":["int64"]},{"Name":"OldName","Docs":"","Typewords":["string"]},{"Name":"NewName","Docs":"","Typewords":["string"]},{"Name":"Flags","Docs":"","Typewords":["[]","string"]}]},
-	"ChangeMailboxCounts": {"Name":"ChangeMailboxCounts","Docs":"","Fields":[{"Name":"MailboxID","Docs":"","Typewords":["int64"]},{"Name":"MailboxName","Docs":"","Typewords":["string"]},{"Name":"Total","Docs":"","Typewords":["int64"]},{"Name":"Deleted","Docs":"","Typewords":["int64"]},{"Name":"Unread","Docs":"","Typewords":["int64"]},{"Name":"Unseen","Docs":"","Typewords":["int64"]},{"Name":"Size","Docs":"","Typewords":["int64"]}]},
+	"ChangeMailboxCounts": {"Name":"ChangeMailboxCounts","Docs":"","Fields":[{"Name":"MailboxID","Docs":"","Typewords":["int64"]},{"Name":"MailboxName","Docs":"","Typewords":["string"]},{"Name":"Total","Docs":"","Typewords":["int64"]},{"Name":"Deleted","Docs":"","Typewords":["int64"]},{"Name":"Unread","Docs":"","Typewords":["int64"]},{"Name":"Unseen","Docs":"","Typewords":["int64"]},{"Name":"Size","Docs":"","Typewords":["int64"]},{"Name":"DeletedSize","Docs":"","Typewords":["int64"]}]},
 	"ChangeMailboxSpecialUse": {"Name":"ChangeMailboxSpecialUse","Docs":"","Fields":[{"Name":"MailboxID","Docs":"","Typewords":["int64"]},{"Name":"MailboxName","Docs":"","Typewords":["string"]},{"Name":"SpecialUse","Docs":"","Typewords":["SpecialUse"]}]},
 	"SpecialUse": {"Name":"SpecialUse","Docs":"","Fields":[{"Name":"Archive","Docs":"","Typewords":["bool"]},{"Name":"Draft","Docs":"","Typewords":["bool"]},{"Name":"Junk","Docs":"","Typewords":["bool"]},{"Name":"Sent","Docs":"","Typewords":["bool"]},{"Name":"Trash","Docs":"","Typewords":["bool"]}]},
 	"ChangeMailboxKeywords": {"Name":"ChangeMailboxKeywords","Docs":"","Fields":[{"Name":"MailboxID","Docs":"","Typewords":["int64"]},{"Name":"MailboxName","Docs":"","Typewords":["string"]},{"Name":"Keywords","Docs":"","Typewords":["[]","string"]}]},
@@ -636,10 +838,12 @@ export const types: TypenameMap = {
 	"ViewMode": {"Name":"ViewMode","Docs":"","Values":[{"Name":"ModeDefault","Value":"","Docs":""},{"Name":"ModeText","Value":"text","Docs":""},{"Name":"ModeHTML","Value":"html","Docs":""},{"Name":"ModeHTMLExt","Value":"htmlext","Docs":""}]},
 	"SecurityResult": {"Name":"SecurityResult","Docs":"","Values":[{"Name":"SecurityResultError","Value":"error","Docs":""},{"Name":"SecurityResultNo","Value":"no","Docs":""},{"Name":"SecurityResultYes","Value":"yes","Docs":""},{"Name":"SecurityResultUnknown","Value":"unknown","Docs":""}]},
 	"Quoting": {"Name":"Quoting","Docs":"","Values":[{"Name":"Default","Value":"","Docs":""},{"Name":"Bottom","Value":"bottom","Docs":""},{"Name":"Top","Value":"top","Docs":""}]},
-	"Localpart": {"Name":"Localpart","Docs":"","Values":null},
+	"RetentionAction": {"Name":"RetentionAction","Docs":"","Values":[{"Name":"RetentionArchive","Value":"archive","Docs":""},{"Name":"RetentionArchiveYear","Value":"archiveyear","Docs":""},{"Name":"RetentionDelete","Value":"delete","Docs":""}]},
 }
 
 export const parser = {
+	PasskeyRequestOptions: (v: any) => parse("PasskeyRequestOptions", v) as PasskeyRequestOptions,
+	PasskeyAssertion: (v: any) => parse("PasskeyAssertion", v) as PasskeyAssertion,
 	Request: (v: any) => parse("Request", v) as Request,
 	Query: (v: any) => parse("Query", v) as Query,
 	Filter: (v: any) => parse("Filter", v) as Filter,
@@ -651,24 +855,36 @@ export const parser = {
 	Address: (v: any) => parse("Address", v) as Address,
 	MessageAddress: (v: any) => parse("MessageAddress", v) as MessageAddress,
 	Domain: (v: any) => parse("Domain", v) as Domain,
+	Invite: (v: any) => parse("Invite", v) as Invite,
+	InviteAttendee: (v: any) => parse("InviteAttendee", v) as InviteAttendee,
 	FromAddressSettings: (v: any) => parse("FromAddressSettings", v) as FromAddressSettings,
 	ComposeMessage: (v: any) => parse("ComposeMessage", v) as ComposeMessage,
 	SubmitMessage: (v: any) => parse("SubmitMessage", v) as SubmitMessage,
 	File: (v: any) => parse("File", v) as File,
 	ForwardAttachments: (v: any) => parse("ForwardAttachments", v) as ForwardAttachments,
+	SubmitResult: (v: any) => parse("SubmitResult", v) as SubmitResult,
+	Upload: (v: any) => parse("Upload", v) as Upload,
 	Mailbox: (v: any) => parse("Mailbox", v) as Mailbox,
 	RecipientSecurity: (v: any) => parse("RecipientSecurity", v) as RecipientSecurity,
 	Settings: (v: any) => parse("Settings", v) as Settings,
 	Ruleset: (v: any) => parse("Ruleset", v) as Ruleset,
+	FilterRule: (v: any) => parse("FilterRule", v) as FilterRule,
+	RetentionRule: (v: any) => parse("RetentionRule", v) as RetentionRule,
+	Result: (v: any) => parse("Result", v) as Result,
+	PGPKey: (v: any) => parse("PGPKey", v) as PGPKey,
+	LinkedAccount: (v: any) => parse("LinkedAccount", v) as LinkedAccount,
+	UnifiedPage: (v: any) => parse("UnifiedPage", v) as UnifiedPage,
+	UnifiedMessage: (v: any) => parse("UnifiedMessage", v) as UnifiedMessage,
+	MessageItem: (v: any) => parse("MessageItem", v) as MessageItem,
+	Message: (v: any) => parse("Message", v) as Message,
+	MessageEnvelope: (v: any) => parse("MessageEnvelope", v) as MessageEnvelope,
+	Attachment: (v: any) => parse("Attachment", v) as Attachment,
 	EventStart: (v: any) => parse("EventStart", v) as EventStart,
 	DomainAddressConfig: (v: any) => parse("DomainAddressConfig", v) as DomainAddressConfig,
+	Identity: (v: any) => parse("Identity", v) as Identity,
 	EventViewErr: (v: any) => parse("EventViewErr", v) as EventViewErr,
 	EventViewReset: (v: any) => parse("EventViewReset", v) as EventViewReset,
 	EventViewMsgs: (v: any) => parse("EventViewMsgs", v) as EventViewMsgs,
-	MessageItem: (v: any) => parse("MessageItem", v) as MessageItem,
-	Message: (v: any) => parse("Message", v) as Message,
-	MessageEnvelope: (v: any) => parse("MessageEnvelope", v) as MessageEnvelope,
-	Attachment: (v: any) => parse("Attachment", v) as Attachment,
 	EventViewChanges: (v: any) => parse("EventViewChanges", v) as EventViewChanges,
 	ChangeMsgAdd: (v: any) => parse("ChangeMsgAdd", v) as ChangeMsgAdd,
 	Flags: (v: any) => parse("Flags", v) as Flags,
@@ -691,7 +907,7 @@ export const parser = {
 	ViewMode: (v: any) => parse("ViewMode", v) as ViewMode,
 	SecurityResult: (v: any) => parse("SecurityResult", v) as SecurityResult,
 	Quoting: (v: any) => parse("Quoting", v) as Quoting,
-	Localpart: (v: any) => parse("Localpart", v) as Localpart,
+	RetentionAction: (v: any) => parse("RetentionAction", v) as RetentionAction,
 }
 
 let defaultOptions: ClientOptions = {slicesNullable: true, mapsNullable: true, nullableOptional: true}
@@ -732,12 +948,67 @@ export class Client {
 	}
 
 	// Login returns a session token for the credentials, or fails with error code
-	// "user:badLogin". Call LoginPrep to get a loginToken.
-	async Login(loginToken: string, username: string, password: string): Promise<CSRFToken> {
+	// "user:badLogin". Call LoginPrep to get a loginToken. If two-factor
+	// authentication is enabled and totpCode is empty, the error code is
+	// "user:totpRequired", and the login must be retried with a code.
+	async Login(loginToken: string, username: string, password: string, totpCode: string): Promise<CSRFToken> {
 		const fn: string = "Login"
+		const paramTypes: string[][] = [["string"],["string"],["string"],["string"]]
+		const returnTypes: string[][] = [["CSRFToken"]]
+		const params: any[] = [loginToken, username, password, totpCode]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as CSRFToken
+	}
+
+	// PasskeyLoginPrep returns options for logging in with a passkey with
+	// navigator.credentials.get in the browser.
+	async PasskeyLoginPrep(): Promise<PasskeyRequestOptions> {
+		const fn: string = "PasskeyLoginPrep"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["PasskeyRequestOptions"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as PasskeyRequestOptions
+	}
+
+	// PasskeyLogin returns a session token for a login with a passkey, started with
+	// PasskeyLoginPrep, or fails with error code "user:loginFailed". Call LoginPrep
+	// to get a loginToken after the user selected a passkey.
+	async PasskeyLogin(loginToken: string, response: PasskeyAssertion): Promise<CSRFToken> {
+		const fn: string = "PasskeyLogin"
+		const paramTypes: string[][] = [["string"],["PasskeyAssertion"]]
+		const returnTypes: string[][] = [["CSRFToken"]]
+		const params: any[] = [loginToken, response]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as CSRFToken
+	}
+
+	// OIDCEnabled returns whether single sign-on through an OpenID Connect identity
+	// provider is configured.
+	async OIDCEnabled(): Promise<boolean> {
+		const fn: string = "OIDCEnabled"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["bool"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as boolean
+	}
+
+	// OIDCLoginPrep starts a single sign-on login, returning the URL of the identity
+	// provider to navigate to. The identity provider sends the browser back to this
+	// page with "code" and "state" query string parameters, for OIDCLogin.
+	async OIDCLoginPrep(): Promise<string> {
+		const fn: string = "OIDCLoginPrep"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["string"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as string
+	}
+
+	// OIDCLogin returns a session token for a single sign-on login started with
+	// OIDCLoginPrep, or fails with error code "user:loginFailed". Call LoginPrep to
+	// get a loginToken.
+	async OIDCLogin(loginToken: string, code: string, state: string): Promise<CSRFToken> {
+		const fn: string = "OIDCLogin"
 		const paramTypes: string[][] = [["string"],["string"],["string"]]
 		const returnTypes: string[][] = [["CSRFToken"]]
-		const params: any[] = [loginToken, username, password]
+		const params: any[] = [loginToken, code, state]
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as CSRFToken
 	}
 
@@ -784,6 +1055,18 @@ export class Client {
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as ParsedMessage
 	}
 
+	// MessageComposeHTML returns the first HTML part of a message, sanitized for
+	// editing in the HTML composer, e.g. when continuing an HTML draft message. Images
+	// are only kept when they reference an inline part with a "cid:" URI. An empty
+	// string is returned if the message has no HTML part.
+	async MessageComposeHTML(msgID: number): Promise<string> {
+		const fn: string = "MessageComposeHTML"
+		const paramTypes: string[][] = [["int64"]]
+		const returnTypes: string[][] = [["string"]]
+		const params: any[] = [msgID]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as string
+	}
+
 	// FromAddressSettingsSave saves per-"From"-address settings.
 	async FromAddressSettingsSave(fas: FromAddressSettings): Promise<void> {
 		const fn: string = "FromAddressSettingsSave"
@@ -822,14 +1105,61 @@ export class Client {
 	// If a Sent mailbox is configured, messages are added to it after submitting
 	// to the delivery queue. If Bcc addresses were present, a header is prepended
 	// to the message stored in the Sent mailbox.
-	async MessageSubmit(m: SubmitMessage): Promise<void> {
+	// 
+	// If undo send is enabled in the settings and no future release is requested,
+	// delivery is held for the configured delay, see MessageSubmitUndo.
+	async MessageSubmit(m: SubmitMessage): Promise<SubmitResult> {
 		const fn: string = "MessageSubmit"
 		const paramTypes: string[][] = [["SubmitMessage"]]
-		const returnTypes: string[][] = []
+		const returnTypes: string[][] = [["SubmitResult"]]
 		const params: any[] = [m]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as SubmitResult
+	}
+
+	// MessageSubmitUndo cancels delivery of a message submitted with MessageSubmit
+	// while it is still held in the queue, and removes the message from the Sent
+	// mailbox. Flags set on the message replied to or forwarded are kept. An error is
+	// returned if the delivery can no longer be canceled.
+	async MessageSubmitUndo(result: SubmitResult): Promise<void> {
+		const fn: string = "MessageSubmitUndo"
+		const paramTypes: string[][] = [["SubmitResult"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [result]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// UploadStart starts an upload of a file, to be added to a message being composed
+	// as attachment, or as inline image if inline is set. The data is sent in chunks
+	// with PUT requests to "upload/<id>?offset=<offset>", which can be resumed after
+	// interruptions. Inline images get a Content-ID for referencing with "cid:".
+	async UploadStart(filename: string, contentType: string, size: number, inline: boolean): Promise<Upload> {
+		const fn: string = "UploadStart"
+		const paramTypes: string[][] = [["string"],["string"],["int64"],["bool"]]
+		const returnTypes: string[][] = [["Upload"]]
+		const params: any[] = [filename, contentType, size, inline]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as Upload
+	}
+
+	// UploadRemove removes an upload, e.g. when an attachment is removed from a
+	// message being composed.
+	async UploadRemove(uploadID: number): Promise<void> {
+		const fn: string = "UploadRemove"
+		const paramTypes: string[][] = [["int64"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [uploadID]
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
 	}
 
+	// DraftUploads returns the uploads kept with a draft message, for continuing to
+	// compose the draft.
+	async DraftUploads(draftMessageID: number): Promise<Upload[] | null> {
+		const fn: string = "DraftUploads"
+		const paramTypes: string[][] = [["int64"]]
+		const returnTypes: string[][] = [["[]","Upload"]]
+		const params: any[] = [draftMessageID]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as Upload[] | null
+	}
+
 	// MessageMove moves messages to another mailbox. If the message is already in
 	// the mailbox an error is returned.
 	async MessageMove(messageIDs: number[] | null, mailboxID: number): Promise<void> {
@@ -907,8 +1237,9 @@ export class Client {
 	}
 
 	// CompleteRecipient returns autocomplete matches for a recipient, returning the
-	// matches, most recently used first, and whether this is the full list and further
-	// requests for longer prefixes aren't necessary.
+	// matches, contacts from the address book first, then other recipients most
+	// recently used first, and whether this is the full list and further requests for
+	// longer prefixes aren't necessary.
 	async CompleteRecipient(search: string): Promise<[string[] | null, boolean]> {
 		const fn: string = "CompleteRecipient"
 		const paramTypes: string[][] = [["string"]]
@@ -947,6 +1278,40 @@ export class Client {
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
 	}
 
+	// ThreadArchive moves all messages of the threads of messageIDs that are in the
+	// same mailbox as one of messageIDs to the designated Archive mailbox. Messages
+	// of the thread in other mailboxes, e.g. Sent, are left alone.
+	async ThreadArchive(messageIDs: number[] | null): Promise<void> {
+		const fn: string = "ThreadArchive"
+		const paramTypes: string[][] = [["[]","int64"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [messageIDs]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// ThreadSeen marks all messages of the threads of messageIDs as read, or as
+	// unread if seen is false. Messages of the threads in all mailboxes are changed.
+	async ThreadSeen(messageIDs: number[] | null, seen: boolean): Promise<void> {
+		const fn: string = "ThreadSeen"
+		const paramTypes: string[][] = [["[]","int64"],["bool"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [messageIDs, seen]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// InviteReply replies to the organizer of a calendar invitation in a message, with
+	// status "ACCEPTED", "TENTATIVE" or "DECLINED". The reply is sent from the
+	// address of the account that is an attendee of the event. Accepted and tentative
+	// events are stored in the calendar of the account, declined events are removed
+	// from it.
+	async InviteReply(messageID: number, status: string): Promise<void> {
+		const fn: string = "InviteReply"
+		const paramTypes: string[][] = [["int64"],["string"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [messageID, status]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
 	// RecipientSecurity looks up security properties of the address in the
 	// single-address message addressee (as it appears in a To/Cc/Bcc/etc header).
 	async RecipientSecurity(messageAddressee: string): Promise<RecipientSecurity> {
@@ -1015,6 +1380,256 @@ export class Client {
 		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
 	}
 
+	// FilterRules returns the filter rules for incoming messages, in order of
+	// evaluation.
+	async FilterRules(): Promise<FilterRule[] | null> {
+		const fn: string = "FilterRules"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["[]","FilterRule"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as FilterRule[] | null
+	}
+
+	// FilterRuleSave adds a filter rule if its ID is 0, or updates an existing rule.
+	// New rules are evaluated after existing rules.
+	async FilterRuleSave(rule: FilterRule): Promise<FilterRule> {
+		const fn: string = "FilterRuleSave"
+		const paramTypes: string[][] = [["FilterRule"]]
+		const returnTypes: string[][] = [["FilterRule"]]
+		const params: any[] = [rule]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as FilterRule
+	}
+
+	// FilterRuleRemove removes a filter rule.
+	async FilterRuleRemove(ruleID: number): Promise<void> {
+		const fn: string = "FilterRuleRemove"
+		const paramTypes: string[][] = [["int64"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [ruleID]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// FilterRulesReorder sets the order in which filter rules are evaluated. All
+	// rule IDs must be present.
+	async FilterRulesReorder(ruleIDs: number[] | null): Promise<void> {
+		const fn: string = "FilterRulesReorder"
+		const paramTypes: string[][] = [["[]","int64"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [ruleIDs]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// FilterRuleApply runs a filter rule on the existing messages in a mailbox, and
+	// returns the number of matching messages. Matching messages are marked and moved
+	// as configured in the rule, discarded messages are moved to the Trash mailbox.
+	// Messages are not forwarded.
+	async FilterRuleApply(ruleID: number, mailboxID: number): Promise<number> {
+		const fn: string = "FilterRuleApply"
+		const paramTypes: string[][] = [["int64"],["int64"]]
+		const returnTypes: string[][] = [["int32"]]
+		const params: any[] = [ruleID, mailboxID]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as number
+	}
+
+	// RetentionRules returns the retention rules for old messages in mailboxes.
+	async RetentionRules(): Promise<RetentionRule[] | null> {
+		const fn: string = "RetentionRules"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["[]","RetentionRule"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as RetentionRule[] | null
+	}
+
+	// RetentionRuleSave adds a retention rule if its ID is 0, or updates an existing
+	// rule. Rules are applied periodically in the background.
+	async RetentionRuleSave(rule: RetentionRule): Promise<RetentionRule> {
+		const fn: string = "RetentionRuleSave"
+		const paramTypes: string[][] = [["RetentionRule"]]
+		const returnTypes: string[][] = [["RetentionRule"]]
+		const params: any[] = [rule]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as RetentionRule
+	}
+
+	// RetentionRuleRemove removes a retention rule.
+	async RetentionRuleRemove(ruleID: number): Promise<void> {
+		const fn: string = "RetentionRuleRemove"
+		const paramTypes: string[][] = [["int64"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [ruleID]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// RetentionRulePreview returns the messages that a rule, which does not have to
+	// be saved, would move or remove when applied now, without changing anything.
+	async RetentionRulePreview(rule: RetentionRule): Promise<Result> {
+		const fn: string = "RetentionRulePreview"
+		const paramTypes: string[][] = [["RetentionRule"]]
+		const returnTypes: string[][] = [["Result"]]
+		const params: any[] = [rule]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as Result
+	}
+
+	// RetentionRuleApply applies a saved rule now, instead of waiting for the
+	// periodic run in the background. The result is recorded in the rule.
+	async RetentionRuleApply(ruleID: number): Promise<Result> {
+		const fn: string = "RetentionRuleApply"
+		const paramTypes: string[][] = [["int64"]]
+		const returnTypes: string[][] = [["Result"]]
+		const params: any[] = [ruleID]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as Result
+	}
+
+	// PGPKeys returns the OpenPGP keys of the account, both own keys and keys of
+	// correspondents. Private keys are not included, see PGPPrivateKey.
+	async PGPKeys(): Promise<PGPKey[] | null> {
+		const fn: string = "PGPKeys"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["[]","PGPKey"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as PGPKey[] | null
+	}
+
+	// PGPKeyImport adds an ASCII-armored OpenPGP public key for an address. For own
+	// keys, privateKey is the ASCII-armored secret key, protected with a passphrase,
+	// and the address must belong to the account. Keys are generated and private keys
+	// are encrypted in the browser. An existing key for the address is replaced.
+	async PGPKeyImport(address: string, publicKey: string, privateKey: string): Promise<PGPKey> {
+		const fn: string = "PGPKeyImport"
+		const paramTypes: string[][] = [["string"],["string"],["string"]]
+		const returnTypes: string[][] = [["PGPKey"]]
+		const params: any[] = [address, publicKey, privateKey]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as PGPKey
+	}
+
+	// PGPKeySave saves whether an own key is sent in Autocrypt headers, and whether it
+	// is published in the Web Key Directory.
+	async PGPKeySave(keyID: number, autocrypt: boolean, wkdPublish: boolean): Promise<void> {
+		const fn: string = "PGPKeySave"
+		const paramTypes: string[][] = [["int64"],["bool"],["bool"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [keyID, autocrypt, wkdPublish]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// PGPKeyRemove removes an OpenPGP key.
+	async PGPKeyRemove(keyID: number): Promise<void> {
+		const fn: string = "PGPKeyRemove"
+		const paramTypes: string[][] = [["int64"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [keyID]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// PGPPrivateKey returns the passphrase-protected ASCII-armored private key for an
+	// own key, for decrypting and signing in the browser.
+	async PGPPrivateKey(keyID: number): Promise<string> {
+		const fn: string = "PGPPrivateKey"
+		const paramTypes: string[][] = [["int64"]]
+		const returnTypes: string[][] = [["string"]]
+		const params: any[] = [keyID]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as string
+	}
+
+	// PGPRecipientKeys returns the known public keys for recipient addresses, for
+	// encrypting a message in the browser. Addresses without known key are not in the
+	// result.
+	async PGPRecipientKeys(addresses: string[] | null): Promise<PGPKey[] | null> {
+		const fn: string = "PGPRecipientKeys"
+		const paramTypes: string[][] = [["[]","string"]]
+		const returnTypes: string[][] = [["[]","PGPKey"]]
+		const params: any[] = [addresses]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as PGPKey[] | null
+	}
+
+	// LinkedAccounts returns the account of the session and the accounts linked to
+	// it, with their addresses, number of unread messages in their Inbox, and for
+	// accounts that are not active their mailboxes, for showing a folder tree of all
+	// accounts alongside the mailboxes of the active account.
+	async LinkedAccounts(): Promise<LinkedAccount[] | null> {
+		const fn: string = "LinkedAccounts"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["[]","LinkedAccount"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as LinkedAccount[] | null
+	}
+
+	// UnifiedInbox returns messages in the Inbox of the account of the session and of
+	// the accounts linked to it, merged into a single list, most recently received
+	// first. Fewer than page.Count messages are returned at the end of the list.
+	async UnifiedInbox(page: UnifiedPage): Promise<UnifiedMessage[] | null> {
+		const fn: string = "UnifiedInbox"
+		const paramTypes: string[][] = [["UnifiedPage"]]
+		const returnTypes: string[][] = [["[]","UnifiedMessage"]]
+		const params: any[] = [page]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as UnifiedMessage[] | null
+	}
+
+	// LinkedAccountAdd links another account to the account of the session, after
+	// verifying its credentials, allowing the session to switch to the account and
+	// to send messages from its addresses. Errors are as for Login. The link remains
+	// until removed with LinkedAccountRemove, also for new sessions. It stops working
+	// when the password or two-factor authentication of the linked account changes,
+	// and while logins to the linked account are disabled.
+	async LinkedAccountAdd(username: string, password: string, totpCode: string): Promise<void> {
+		const fn: string = "LinkedAccountAdd"
+		const paramTypes: string[][] = [["string"],["string"],["string"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [username, password, totpCode]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// LinkedAccountRemove removes the link to an account. If it is the active account,
+	// the session switches back to the account of the session.
+	async LinkedAccountRemove(linkID: number): Promise<void> {
+		const fn: string = "LinkedAccountRemove"
+		const paramTypes: string[][] = [["int64"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [linkID]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// LinkedAccountActivate makes an account active for the session, either the
+	// account of the session or an account linked to it. The client must reload its
+	// state, e.g. by reconnecting, because mailboxes and messages are of the active
+	// account.
+	async LinkedAccountActivate(account: string): Promise<void> {
+		const fn: string = "LinkedAccountActivate"
+		const paramTypes: string[][] = [["string"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [account]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// PushKey returns the public key of the server for Web Push, for subscribing in
+	// the browser.
+	async PushKey(): Promise<string> {
+		const fn: string = "PushKey"
+		const paramTypes: string[][] = []
+		const returnTypes: string[][] = [["string"]]
+		const params: any[] = []
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as string
+	}
+
+	// PushSubscribe adds a Web Push subscription of this browser, for notifications
+	// about new messages in the Inbox. P256dh and auth are the base64url-encoded keys
+	// of the subscription. Label describes the device.
+	async PushSubscribe(endpoint: string, p256dh: string, auth: string, label: string): Promise<void> {
+		const fn: string = "PushSubscribe"
+		const paramTypes: string[][] = [["string"],["string"],["string"],["string"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [endpoint, p256dh, auth, label]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
+	// PushUnsubscribe removes the Web Push subscription for endpoint, of this browser.
+	async PushUnsubscribe(endpoint: string): Promise<void> {
+		const fn: string = "PushUnsubscribe"
+		const paramTypes: string[][] = [["string"]]
+		const returnTypes: string[][] = []
+		const params: any[] = [endpoint]
+		return await _sherpaCall(this.baseURL, this.authState, { ...this.options }, paramTypes, returnTypes, fn, params) as void
+	}
+
 	// SSETypes exists to ensure the generated API contains the types, for use in SSE events.
 	async SSETypes(): Promise<[EventStart, EventViewErr, EventViewReset, EventViewMsgs, EventViewChanges, ChangeMsgAdd, ChangeMsgRemove, ChangeMsgFlags, ChangeMsgThread, ChangeMailboxRemove, ChangeMailboxAdd, ChangeMailboxRename, ChangeMailboxCounts, ChangeMailboxSpecialUse, ChangeMailboxKeywords, Flags]> {
 		const fn: string = "SSETypes"
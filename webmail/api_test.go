@@ -2,6 +2,9 @@ package webmail
 
 import (
 	"context"
+	"crypto/ecdh"
+	cryptorand "crypto/rand"
+	"encoding/base64"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -9,6 +12,7 @@ import (
 	"path/filepath"
 	"runtime/debug"
 	"slices"
+	"strings"
 	"testing"
 
 	"github.com/mjl-/bstore"
@@ -90,11 +94,11 @@ func TestAPI(t *testing.T) {
 	api := Webmail{maxMessageSize: 1024 * 1024, cookiePath: "/webmail/"}
 
 	// Test login, and rate limiter.
-	loginReqInfo := requestInfo{log, "mjl@mox.example", nil, "", httptest.NewRecorder(), &http.Request{RemoteAddr: "1.1.1.1:1234"}}
+	loginReqInfo := requestInfo{log, "mjl@mox.example", nil, "", "", httptest.NewRecorder(), &http.Request{RemoteAddr: "1.1.1.1:1234"}}
 	loginctx := context.WithValue(ctxbg, requestInfoCtxKey, loginReqInfo)
 
 	// Missing login token.
-	tneedErrorCode(t, "user:error", func() { api.Login(loginctx, "", "mjl@mox.example", pw0) })
+	tneedErrorCode(t, "user:error", func() { api.Login(loginctx, "", "mjl@mox.example", pw0, "") })
 
 	// Login with loginToken.
 	loginCookie := &http.Cookie{Name: "webmaillogin"}
@@ -119,7 +123,7 @@ func TestAPI(t *testing.T) {
 			}
 		}()
 
-		api.Login(loginctx, loginCookie.Value, username, password)
+		api.Login(loginctx, loginCookie.Value, username, password, "")
 	}
 	testLogin("mjl@mox.example", pw0)
 	testLogin("mjl@mox.example", pw1)
@@ -138,7 +142,7 @@ func TestAPI(t *testing.T) {
 	testLogin("bad@bad.example", pw0, "user:error")
 
 	// Context with different IP, for clear rate limit history.
-	reqInfo := requestInfo{log, "mjl@mox.example", acc, "", nil, &http.Request{RemoteAddr: "127.0.0.1:1234"}}
+	reqInfo := requestInfo{log, "mjl@mox.example", acc, "", "mjl", nil, &http.Request{RemoteAddr: "127.0.0.1:1234"}}
 	ctx := context.WithValue(ctxbg, requestInfoCtxKey, reqInfo)
 
 	// FlagsAdd
@@ -229,6 +233,11 @@ func TestAPI(t *testing.T) {
 	pm := api.ParsedMessage(ctx, inboxText.ID)
 	tcompare(t, pm.ViewMode, store.ModeDefault)
 
+	// Settings for the domain apply if there are none for the address.
+	api.FromAddressSettingsSave(ctx, store.FromAddressSettings{FromAddress: "@mox.example", ViewMode: store.ModeHTML})
+	pm = api.ParsedMessage(ctx, inboxText.ID)
+	tcompare(t, pm.ViewMode, store.ModeHTML)
+
 	api.FromAddressSettingsSave(ctx, store.FromAddressSettings{FromAddress: "mjl@mox.example", ViewMode: store.ModeHTMLExt})
 	pm = api.ParsedMessage(ctx, inboxText.ID)
 	tcompare(t, pm.ViewMode, store.ModeHTMLExt)
@@ -279,6 +288,67 @@ func TestAPI(t *testing.T) {
 	tdeliver(t, acc, testbox1Alt)
 	tdeliver(t, acc, inboxAltRel)
 
+	// ThreadSeen, inboxAlt and testbox1Alt have the same Message-ID and are in the same thread.
+	tmsgSeen := func(id int64) bool {
+		t.Helper()
+		m := store.Message{ID: id}
+		err := acc.DB.Get(ctx, &m)
+		tcheck(t, err, "get message")
+		return m.Seen
+	}
+	api.ThreadSeen(ctx, []int64{inboxAlt.ID}, true)
+	tcompare(t, tmsgSeen(inboxAlt.ID), true)
+	tcompare(t, tmsgSeen(testbox1Alt.ID), true)
+	tcompare(t, tmsgSeen(inboxAttachments.ID), false)
+	api.ThreadSeen(ctx, []int64{testbox1Alt.ID}, false)
+	tcompare(t, tmsgSeen(inboxAlt.ID), false)
+	tcompare(t, tmsgSeen(testbox1Alt.ID), false)
+	tneedError(t, func() { api.ThreadSeen(ctx, []int64{}, true) })  // No messages.
+	tneedError(t, func() { api.ThreadSeen(ctx, []int64{0}, true) }) // Bad ID.
+
+	// ThreadArchive, only messages from the thread in the same mailbox are archived.
+	api.MailboxSetSpecialUse(ctx, store.Mailbox{ID: archive.ID, SpecialUse: store.SpecialUse{Archive: true}})
+	api.ThreadArchive(ctx, []int64{inboxAlt.ID})
+	tmsgMailboxID := func(id int64) int64 {
+		t.Helper()
+		m := store.Message{ID: id}
+		err := acc.DB.Get(ctx, &m)
+		tcheck(t, err, "get message")
+		return m.MailboxID
+	}
+	tcompare(t, tmsgMailboxID(inboxAlt.ID), archive.ID)
+	tcompare(t, tmsgMailboxID(testbox1Alt.ID), testbox1.ID)
+	api.ThreadArchive(ctx, []int64{inboxAlt.ID}) // Already archived, no-op.
+	tneedError(t, func() { api.ThreadArchive(ctx, []int64{}) })
+	// Restore.
+	api.MessageMove(ctx, []int64{inboxAlt.ID}, inbox.ID)
+
+	// Filter rules.
+	tneedError(t, func() { api.FilterRuleSave(ctx, store.FilterRule{Subject: "x"}) })                      // No action.
+	tneedError(t, func() { api.FilterRuleSave(ctx, store.FilterRule{ID: 999, Subject: "x", Seen: true}) }) // Unknown ID.
+	fr1 := api.FilterRuleSave(ctx, store.FilterRule{Subject: "alt and rel", Mailbox: "Archive", Flagged: true, Keywords: []string{"filtered"}})
+	fr2 := api.FilterRuleSave(ctx, store.FilterRule{From: "nobody@example.org", Seen: true})
+	tcompare(t, len(api.FilterRules(ctx)), 2)
+	tneedError(t, func() { api.FilterRulesReorder(ctx, []int64{fr2.ID}) }) // Missing rule.
+	api.FilterRulesReorder(ctx, []int64{fr2.ID, fr1.ID})
+	tcompare(t, api.FilterRules(ctx)[0].ID, fr2.ID)
+	tneedError(t, func() { api.FilterRuleApply(ctx, 999, inbox.ID) }) // Unknown rule.
+	tneedError(t, func() { api.FilterRuleApply(ctx, fr1.ID, 999) })   // Unknown mailbox.
+	tcompare(t, api.FilterRuleApply(ctx, fr2.ID, inbox.ID), 0)
+	tcompare(t, api.FilterRuleApply(ctx, fr1.ID, inbox.ID), 1)
+	tcompare(t, tmsgMailboxID(inboxAltRel.ID), archive.ID)
+	maltrel := store.Message{ID: inboxAltRel.ID}
+	err = acc.DB.Get(ctx, &maltrel)
+	tcheck(t, err, "get message")
+	tcompare(t, maltrel.Flagged, true)
+	tcompare(t, maltrel.Keywords, []string{"filtered"})
+	api.FilterRuleRemove(ctx, fr2.ID)
+	tneedError(t, func() { api.FilterRuleRemove(ctx, fr2.ID) }) // Already removed.
+	api.FilterRuleRemove(ctx, fr1.ID)
+	// Restore.
+	api.MessageMove(ctx, []int64{inboxAltRel.ID}, inbox.ID)
+	api.FlagsClear(ctx, []int64{inboxAltRel.ID}, []string{`\flagged`, "filtered"})
+
 	// MessageCompose
 	draftID := api.MessageCompose(ctx, ComposeMessage{
 		From:     "mjl@mox.example",
@@ -385,6 +455,220 @@ func TestAPI(t *testing.T) {
 		TextBody: fmt.Sprintf("%80s", "tést"),
 	})
 
+	// Undo send.
+	settings := store.Settings{ID: 1}
+	err = acc.DB.Get(ctx, &settings)
+	tcheck(t, err, "get settings")
+	settings.SendUndoDelay = 61
+	tneedError(t, func() { api.SettingsSave(ctx, settings) })
+	settings.SendUndoDelay = 10
+	settings.Language = "xx"
+	tneedError(t, func() { api.SettingsSave(ctx, settings) }) // Unknown language.
+	settings.Language = ""
+	api.SettingsSave(ctx, settings)
+	undoMsg := SubmitMessage{
+		From:     "mjl@mox.example",
+		To:       []string{"mjl+to@mox.example"},
+		Subject:  "undo",
+		TextBody: "test",
+	}
+	sr := api.MessageSubmit(ctx, undoMsg)
+	if sr.UndoUntil == nil || len(sr.QueueMsgIDs) != 1 || sr.SentMessageID == 0 {
+		t.Fatalf("bad submit result %#v", sr)
+	}
+	api.MessageSubmitUndo(ctx, sr)
+	tneedError(t, func() { api.MessageSubmitUndo(ctx, sr) }) // Already canceled.
+	sentm := store.Message{ID: sr.SentMessageID}
+	err = acc.DB.Get(ctx, &sentm)
+	tcheck(t, err, "get sent message")
+	tcompare(t, sentm.Expunged, true)
+	settings.SendUndoDelay = 0
+	api.SettingsSave(ctx, settings)
+	sr = api.MessageSubmit(ctx, undoMsg)
+	tcompare(t, sr.UndoUntil == nil, true)
+	tneedError(t, func() { api.MessageSubmitUndo(ctx, sr) }) // Not held.
+
+	// Web Push subscriptions.
+	pushKey := api.PushKey(ctx)
+	tcompare(t, len(pushKey), 87) // Uncompressed P-256 key, base64url.
+	pushPriv, err := ecdh.P256().GenerateKey(cryptorand.Reader)
+	tcheck(t, err, "generate key")
+	p256dh := base64.RawURLEncoding.EncodeToString(pushPriv.PublicKey().Bytes())
+	pushAuth := base64.RawURLEncoding.EncodeToString(make([]byte, 16))
+	tneedError(t, func() { api.PushSubscribe(ctx, "http://push.example/x", p256dh, pushAuth, "test") }) // Not https.
+	tneedError(t, func() { api.PushSubscribe(ctx, "https://push.example/x", "bad", pushAuth, "test") })
+	api.PushSubscribe(ctx, "https://push.example/x", p256dh, pushAuth, "test")
+	api.PushSubscribe(ctx, "https://push.example/x", p256dh, pushAuth, "test") // Replaces.
+	pushSubs, err := acc.PushSubscriptions(ctx)
+	tcheck(t, err, "list push subscriptions")
+	tcompare(t, len(pushSubs), 1)
+	api.PushUnsubscribe(ctx, "https://push.example/x")
+	pushSubs, err = acc.PushSubscriptions(ctx)
+	tcheck(t, err, "list push subscriptions")
+	tcompare(t, len(pushSubs), 0)
+
+	// Linked accounts, for using another account in the session.
+	accOther, err := store.OpenAccount(log, "other")
+	tcheck(t, err, "open account")
+	defer func() {
+		err := accOther.Close()
+		pkglog.Check(err, "closing account")
+	}()
+	err = accOther.SetPassword(log, "test1234")
+	tcheck(t, err, "set password")
+	linkRec := httptest.NewRecorder()
+	linkReqInfo := requestInfo{log, "mjl@mox.example", acc, "", "mjl", linkRec, &http.Request{RemoteAddr: "127.0.0.1:1234"}}
+	linkctx := context.WithValue(ctxbg, requestInfoCtxKey, linkReqInfo)
+	tneedErrorCode(t, "user:loginFailed", func() { api.LinkedAccountAdd(linkctx, "other@mox.example", "bad", "") })
+	tneedErrorCode(t, "user:error", func() { api.LinkedAccountAdd(linkctx, "mjl@mox.example", pw0, "") }) // Cannot link to self.
+	api.LinkedAccountAdd(linkctx, "other@mox.example", "test1234", "")
+	linked := api.LinkedAccounts(linkctx)
+	tcompare(t, len(linked), 2)
+	tcompare(t, linked[0].Account, "mjl")
+	tcompare(t, linked[0].Active, true)
+	tcompare(t, linked[1].Account, "other")
+	tcompare(t, linked[1].LoginAddress, "other@mox.example")
+	tcompare(t, len(linked[0].Mailboxes), 0) // Active account, mailboxes come over SSE.
+	tcompare(t, len(linked[1].Mailboxes) > 0, true)
+
+	// Unified inbox, with messages of both accounts, in pages.
+	otherInbox := &testmsg{"Inbox", store.Flags{}, nil, msgText, zerom, 0}
+	tdeliver(t, accOther, otherInbox)
+	mbInbox, err := bstore.QueryDB[store.Mailbox](ctx, acc.DB).FilterNonzero(store.Mailbox{Name: "Inbox"}).Get()
+	tcheck(t, err, "get inbox")
+	ninbox, err := bstore.QueryDB[store.Message](ctx, acc.DB).FilterNonzero(store.Message{MailboxID: mbInbox.ID}).FilterEqual("Expunged", false).Count()
+	tcheck(t, err, "count inbox messages")
+	unified := api.UnifiedInbox(linkctx, UnifiedPage{Count: 1000})
+	tcompare(t, len(unified), ninbox+1)
+	tcompare(t, unified[0].Account, "other")
+	tcompare(t, unified[0].MessageItem.Message.ID, otherInbox.ID)
+	var paged []UnifiedMessage
+	page := UnifiedPage{Count: 2}
+	for {
+		l := api.UnifiedInbox(linkctx, page)
+		paged = append(paged, l...)
+		if len(l) < page.Count {
+			break
+		}
+		last := l[len(l)-1]
+		page.AnchorReceived = last.MessageItem.Message.Received
+		page.AnchorAccount = last.Account
+		page.AnchorMessageID = last.MessageItem.Message.ID
+	}
+	tcompare(t, len(paged), len(unified))
+	for i := range paged {
+		tcompare(t, paged[i].Account, unified[i].Account)
+		tcompare(t, paged[i].MessageItem.Message.ID, unified[i].MessageItem.Message.ID)
+	}
+	tneedError(t, func() { api.UnifiedInbox(linkctx, UnifiedPage{}) })
+
+	// Send from linked account, added to its Sent mailbox.
+	linkedMsg := SubmitMessage{
+		From:     "other@mox.example",
+		To:       []string{"mjl+to@mox.example"},
+		Subject:  "linked",
+		TextBody: "test",
+	}
+	tneedError(t, func() { api.MessageSubmit(ctx, linkedMsg) }) // Address not of active account.
+	linkedMsg.Account = "other"
+	sr = api.MessageSubmit(ctx, linkedMsg)
+	tcompare(t, sr.Account, "other")
+	sentm = store.Message{ID: sr.SentMessageID}
+	err = accOther.DB.Get(ctx, &sentm)
+	tcheck(t, err, "get sent message in linked account")
+	linkedMsg.Account = "nolink"
+	tneedError(t, func() { api.MessageSubmit(ctx, linkedMsg) })
+
+	api.LinkedAccountActivate(linkctx, "other")
+	tcompare(t, strings.HasPrefix(linkRec.Header().Get("Set-Cookie"), "webmailaccount=other;"), true)
+	tneedError(t, func() { api.LinkedAccountActivate(linkctx, "nolink") })
+	api.LinkedAccountRemove(linkctx, linked[1].LinkID)
+	tcompare(t, len(api.LinkedAccounts(linkctx)), 1)
+	linkedMsg.Account = "other"
+	tneedError(t, func() { api.MessageSubmit(ctx, linkedMsg) })
+
+	// Links stop working when the password of the linked account changes.
+	api.LinkedAccountAdd(linkctx, "other@mox.example", "test1234", "")
+	tcompare(t, len(api.LinkedAccounts(linkctx)), 2)
+	err = accOther.SetPassword(log, "test5678")
+	tcheck(t, err, "set password")
+	tneedErrorCode(t, "user:error", func() { api.LinkedAccountActivate(linkctx, "other") })
+	tneedError(t, func() { api.MessageSubmit(ctx, linkedMsg) })
+	tcompare(t, len(api.LinkedAccounts(linkctx)), 1) // Invalid link is removed.
+
+	// Uploads, as attachment and inline image, kept with draft.
+	tneedError(t, func() { api.UploadStart(ctx, "big.bin", "", api.maxMessageSize+1, false) })
+	tneedError(t, func() { api.UploadStart(ctx, "test.png", "bad content-type", 1, false) })
+	upload := api.UploadStart(ctx, "test.png", "image/png", 4, true)
+	tcompare(t, upload.ContentID != "", true)
+	uploadMsg := SubmitMessage{
+		From:      "mjl@mox.example",
+		To:        []string{"mjl+to@mox.example"},
+		Subject:   "inline image",
+		TextBody:  "test",
+		UploadIDs: []int64{upload.ID},
+	}
+	tneedError(t, func() { api.MessageSubmit(ctx, uploadMsg) }) // Upload not complete.
+	_, err = acc.UploadWrite(ctx, upload.ID, 0, []byte("test"))
+	tcheck(t, err, "write upload")
+	uploadDraftID := api.MessageCompose(ctx, ComposeMessage{
+		From:      "mjl@mox.example",
+		TextBody:  "draft with upload",
+		UploadIDs: []int64{upload.ID},
+	}, drafts.ID)
+	tcompare(t, len(api.DraftUploads(ctx, uploadDraftID)), 1)
+	uploadMsg.DraftMessageID = uploadDraftID
+	api.MessageSubmit(ctx, uploadMsg)
+	tneedError(t, func() { api.UploadRemove(ctx, upload.ID) }) // Removed after sending.
+
+	// HTML message, with inline image and derived text part.
+	upload = api.UploadStart(ctx, "test.png", "image/png", 4, true)
+	_, err = acc.UploadWrite(ctx, upload.ID, 0, []byte("test"))
+	tcheck(t, err, "write upload")
+	htmlMsg := SubmitMessage{
+		From:      "mjl@mox.example",
+		To:        []string{"mjl+to@mox.example"},
+		Subject:   "html",
+		HTMLBody:  `<p onclick="x()">hi <b>there</b></p><img src="cid:` + upload.ContentID + `"><script>alert(1)</script>`,
+		UploadIDs: []int64{upload.ID},
+	}
+	sr = api.MessageSubmit(ctx, htmlMsg)
+	pm = api.ParsedMessage(ctx, sr.SentMessageID)
+	tcompare(t, pm.HasHTML, true)
+	tcompare(t, pm.Texts, []string{"hi there\r\n\r\n[image]\r\n"})
+	tcompare(t, strings.ToLower(pm.Part.MediaSubType), "alternative")
+	tcompare(t, strings.ToLower(pm.Part.Parts[1].MediaSubType), "related")
+	tcompare(t, api.MessageComposeHTML(ctx, sr.SentMessageID), `<p>hi <b>there</b></p><img src="cid:`+upload.ContentID+`"/>`+"\n")
+	textDraftID := api.MessageCompose(ctx, ComposeMessage{From: "mjl@mox.example", TextBody: "text"}, drafts.ID)
+	tcompare(t, api.MessageComposeHTML(ctx, textDraftID), "")
+
+	// Reply to invitation.
+	inboxInvite := &testmsg{"Inbox", store.Flags{}, nil, msgInvite, zerom, 0}
+	tdeliver(t, acc, inboxInvite)
+	pm = api.ParsedMessage(ctx, inboxInvite.ID)
+	if pm.Invite == nil {
+		t.Fatalf("missing invite in parsed message")
+	}
+	tcompare(t, pm.Invite.Path, []int{1})
+	tcompare(t, pm.Invite.Method, "REQUEST")
+	tcompare(t, pm.Invite.Summary, "Planning")
+	tcompare(t, pm.Invite.Organizer, InviteAttendee{"organizer", "mjl+org@mox.example", ""})
+	tcompare(t, pm.Invite.Attendees, []InviteAttendee{{"mjl", "mjl@mox.example", "NEEDS-ACTION"}})
+	tneedError(t, func() { api.InviteReply(ctx, inboxInvite.ID, "bogus") })
+	tneedError(t, func() { api.InviteReply(ctx, inboxText.ID, "ACCEPTED") }) // No invitation.
+	api.InviteReply(ctx, inboxInvite.ID, "ACCEPTED")
+	events, err := acc.CalendarEvents(ctxbg)
+	tcheck(t, err, "list calendar events")
+	tcompare(t, len(events), 1)
+	tcompare(t, events[0].UID, "invite1@mox.example")
+	if !strings.Contains(events[0].ICal, "PARTSTAT=ACCEPTED") || strings.Contains(events[0].ICal, "METHOD:") {
+		t.Fatalf("bad stored event %q", events[0].ICal)
+	}
+	api.InviteReply(ctx, inboxInvite.ID, "DECLINED")
+	events, err = acc.CalendarEvents(ctxbg)
+	tcheck(t, err, "list calendar events")
+	tcompare(t, len(events), 0)
+
 	// Send without special-use Sent mailbox.
 	api.MailboxSetSpecialUse(ctx, store.Mailbox{ID: sent.ID, SpecialUse: store.SpecialUse{}})
 	api.MessageSubmit(ctx, SubmitMessage{
@@ -456,8 +740,15 @@ func TestAPI(t *testing.T) {
 	l, full := api.CompleteRecipient(ctx, "doesnotexist")
 	tcompare(t, len(l), 0)
 	tcompare(t, full, true)
+	// Recipients of sent messages were harvested as contacts, sorted by name.
 	l, full = api.CompleteRecipient(ctx, "cc2")
-	tcompare(t, l, []string{"mjl cc2 <mjl+cc2@mox.example>", "mjl bcc2 <mjl+bcc2@mox.example>"})
+	tcompare(t, l, []string{"mjl bcc2 <mjl+bcc2@mox.example>", "mjl cc2 <mjl+cc2@mox.example>"})
+	tcompare(t, full, true)
+	// Contacts match on name too.
+	_, err = acc.ContactSave(ctx, store.Contact{Name: "Some Person", Emails: []string{"person@other.example"}})
+	tcheck(t, err, "save contact")
+	l, full = api.CompleteRecipient(ctx, "some")
+	tcompare(t, l, []string{"Some Person <person@other.example>"})
 	tcompare(t, full, true)
 
 	// RecipientSecurity
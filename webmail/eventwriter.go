@@ -28,7 +28,7 @@ type eventWriter struct {
 
 	// Before writing an event, we check if session is still valid. If not, we send a
 	// fatal error instead.
-	accountName  string
+	accountName  string // Of the session, can be different from the account for the events.
 	sessionToken store.SessionToken
 
 	wrote  bool // To be reset by user, set on write.
@@ -0,0 +1,455 @@
+package webmail
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+
+	"github.com/mjl-/mox/message"
+)
+
+// Elements allowed in HTML messages composed in webmail, with their allowed
+// attributes. Other elements are replaced by their content, except for the
+// elements in composeHTMLDrop, which are removed including their content. No
+// style attributes or other ways to load external resources are allowed.
+var composeHTMLElements = map[atom.Atom][]string{
+	atom.P:          nil,
+	atom.Br:         nil,
+	atom.Div:        nil,
+	atom.Span:       nil,
+	atom.B:          nil,
+	atom.Strong:     nil,
+	atom.I:          nil,
+	atom.Em:         nil,
+	atom.U:          nil,
+	atom.S:          nil,
+	atom.Strike:     nil,
+	atom.Sub:        nil,
+	atom.Sup:        nil,
+	atom.Ul:         nil,
+	atom.Ol:         nil,
+	atom.Li:         nil,
+	atom.Blockquote: nil,
+	atom.Pre:        nil,
+	atom.Code:       nil,
+	atom.H1:         nil,
+	atom.H2:         nil,
+	atom.H3:         nil,
+	atom.H4:         nil,
+	atom.H5:         nil,
+	atom.H6:         nil,
+	atom.Hr:         nil,
+	atom.Table:      nil,
+	atom.Thead:      nil,
+	atom.Tbody:      nil,
+	atom.Tfoot:      nil,
+	atom.Tr:         nil,
+	atom.Td:         {"colspan", "rowspan"},
+	atom.Th:         {"colspan", "rowspan"},
+	atom.A:          {"href"},
+	atom.Img:        {"src", "alt", "width", "height"},
+}
+
+// Elements removed from composed HTML messages, including their content.
+var composeHTMLDrop = map[atom.Atom]bool{
+	atom.Head:     true,
+	atom.Title:    true,
+	atom.Script:   true,
+	atom.Style:    true,
+	atom.Noscript: true,
+	atom.Template: true,
+	atom.Iframe:   true,
+	atom.Frame:    true,
+	atom.Frameset: true,
+	atom.Object:   true,
+	atom.Embed:    true,
+	atom.Applet:   true,
+	atom.Svg:      true,
+	atom.Math:     true,
+	atom.Input:    true,
+	atom.Textarea: true,
+	atom.Select:   true,
+	atom.Button:   true,
+}
+
+// sanitizeHTML returns the HTML fragment s with only the elements and attributes
+// of composeHTMLElements. Links must be http, https or mailto URLs. Images must
+// have a "cid:" source for which keepCID returns true, i.e. that references an
+// inline image sent with the message. Other images are removed.
+func sanitizeHTML(s string, keepCID func(cid string) bool) (string, error) {
+	body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(s), body)
+	if err != nil {
+		return "", fmt.Errorf("parsing html: %v", err)
+	}
+	for _, n := range nodes {
+		body.AppendChild(n)
+	}
+	sanitizeHTMLChildren(body, keepCID)
+
+	var b strings.Builder
+	for c := body.FirstChild; c != nil; c = c.NextSibling {
+		if err := html.Render(&b, c); err != nil {
+			return "", fmt.Errorf("rendering html: %v", err)
+		}
+	}
+	return b.String(), nil
+}
+
+func sanitizeHTMLChildren(n *html.Node, keepCID func(cid string) bool) {
+	var next *html.Node
+	for c := n.FirstChild; c != nil; c = next {
+		next = c.NextSibling
+
+		switch c.Type {
+		case html.TextNode:
+			continue
+		case html.ElementNode:
+		default:
+			// Comments, doctypes.
+			n.RemoveChild(c)
+			continue
+		}
+
+		allowed, ok := composeHTMLElements[c.DataAtom]
+		if c.Namespace != "" || composeHTMLDrop[c.DataAtom] {
+			n.RemoveChild(c)
+			continue
+		} else if !ok {
+			// Replace with content.
+			sanitizeHTMLChildren(c, keepCID)
+			for c.FirstChild != nil {
+				gc := c.FirstChild
+				c.RemoveChild(gc)
+				n.InsertBefore(gc, c)
+			}
+			n.RemoveChild(c)
+			continue
+		}
+
+		var attrs []html.Attribute
+		for _, a := range c.Attr {
+			key := strings.ToLower(a.Key)
+			if a.Namespace != "" || !slices.Contains(allowed, key) {
+				continue
+			}
+			switch key {
+			case "href":
+				u, err := url.Parse(strings.TrimSpace(a.Val))
+				if err != nil || u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "mailto" {
+					continue
+				}
+				a.Val = u.String()
+			case "src":
+				cid, ok := strings.CutPrefix(strings.TrimSpace(a.Val), "cid:")
+				if !ok || cid == "" || !keepCID(cid) {
+					continue
+				}
+				a.Val = "cid:" + cid
+			case "width", "height", "colspan", "rowspan":
+				if !composeHTMLNumber.MatchString(a.Val) {
+					continue
+				}
+			}
+			a.Key = key
+			attrs = append(attrs, a)
+		}
+		c.Attr = attrs
+
+		if c.DataAtom == atom.Img && !htmlHasAttr(c, "src") {
+			n.RemoveChild(c)
+			continue
+		}
+
+		sanitizeHTMLChildren(c, keepCID)
+	}
+}
+
+var composeHTMLNumber = regexp.MustCompile(`^[0-9]{1,4}$`)
+
+func htmlHasAttr(n *html.Node, key string) bool {
+	return htmlAttr(n, key) != ""
+}
+
+func htmlAttr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// htmlPart returns the first text/html part of a message, or nil.
+func htmlPart(p *message.Part) *message.Part {
+	if p.MediaType == "TEXT" && p.MediaSubType == "HTML" {
+		return p
+	}
+	for i := range p.Parts {
+		if hp := htmlPart(&p.Parts[i]); hp != nil {
+			return hp
+		}
+	}
+	return nil
+}
+
+// htmlText returns a plain text version of a sanitized HTML fragment, for the
+// text/plain alternative of an HTML message. Paragraphs are separated by an empty
+// line, list items are prefixed with "- " or a number, quoted text with "> ", and
+// link URLs are added after the link text.
+func htmlText(s string) string {
+	body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(s), body)
+	if err != nil {
+		return ""
+	}
+	var w htmlTextWriter
+	for _, n := range nodes {
+		w.node(n)
+	}
+	return w.String()
+}
+
+type htmlTextWriter struct {
+	b   strings.Builder
+	pre int // Inside pre elements, whitespace is kept.
+}
+
+// String returns the text, with trailing whitespace removed from lines and at most
+// one empty line between paragraphs.
+func (w *htmlTextWriter) String() string {
+	lines := strings.Split(w.b.String(), "\n")
+	var r []string
+	for _, l := range lines {
+		l = strings.TrimRight(l, " \t")
+		if l == "" && (len(r) == 0 || r[len(r)-1] == "") {
+			continue
+		}
+		r = append(r, l)
+	}
+	for len(r) > 0 && r[len(r)-1] == "" {
+		r = r[:len(r)-1]
+	}
+	if len(r) == 0 {
+		return ""
+	}
+	return strings.Join(r, "\n") + "\n"
+}
+
+func (w *htmlTextWriter) atLineStart() bool {
+	return w.b.Len() == 0 || strings.HasSuffix(w.b.String(), "\n")
+}
+
+func (w *htmlTextWriter) newline() {
+	if !w.atLineStart() {
+		w.b.WriteString("\n")
+	}
+}
+
+func (w *htmlTextWriter) blankline() {
+	w.newline()
+	w.b.WriteString("\n")
+}
+
+func (w *htmlTextWriter) text(s string) {
+	if w.pre > 0 {
+		w.b.WriteString(s)
+		return
+	}
+
+	// Collapse whitespace, like browsers do.
+	space := func() {
+		if !w.atLineStart() && !strings.HasSuffix(w.b.String(), " ") {
+			w.b.WriteString(" ")
+		}
+	}
+	words := strings.Fields(s)
+	if len(words) == 0 {
+		if s != "" {
+			space()
+		}
+		return
+	}
+	if strings.TrimLeftFunc(s, unicode.IsSpace) != s {
+		space()
+	}
+	w.b.WriteString(strings.Join(words, " "))
+	if strings.TrimRightFunc(s, unicode.IsSpace) != s {
+		w.b.WriteString(" ")
+	}
+}
+
+func (w *htmlTextWriter) children(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		w.node(c)
+	}
+}
+
+// prefixed writes the text for the children of n with prefix before the first
+// line, and indent before the other lines.
+func (w *htmlTextWriter) prefixed(n *html.Node, prefix, indent string) {
+	sub := htmlTextWriter{pre: w.pre}
+	sub.children(n)
+	t := strings.TrimRight(sub.String(), "\n")
+	w.newline()
+	for i, l := range strings.Split(t, "\n") {
+		p := indent
+		if i == 0 {
+			p = prefix
+		}
+		if l == "" {
+			p = strings.TrimRight(p, " ")
+		}
+		w.b.WriteString(p + l + "\n")
+	}
+}
+
+func (w *htmlTextWriter) node(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		w.text(n.Data)
+		return
+	case html.ElementNode:
+	default:
+		return
+	}
+
+	switch n.DataAtom {
+	case atom.Br:
+		w.b.WriteString("\n")
+	case atom.Hr:
+		w.newline()
+		w.b.WriteString("----\n")
+	case atom.P, atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6, atom.Table:
+		w.blankline()
+		w.children(n)
+		w.blankline()
+	case atom.Pre:
+		w.blankline()
+		w.pre++
+		w.children(n)
+		w.pre--
+		w.blankline()
+	case atom.Div, atom.Tr, atom.Ul, atom.Ol:
+		w.newline()
+		w.children(n)
+		w.newline()
+	case atom.Td, atom.Th:
+		w.children(n)
+		w.text(" ")
+	case atom.Blockquote:
+		w.newline()
+		w.prefixed(n, "> ", "> ")
+	case atom.Li:
+		prefix := "- "
+		if n.Parent != nil && n.Parent.DataAtom == atom.Ol {
+			index := 1
+			for c := n.PrevSibling; c != nil; c = c.PrevSibling {
+				if c.Type == html.ElementNode && c.DataAtom == atom.Li {
+					index++
+				}
+			}
+			prefix = fmt.Sprintf("%d. ", index)
+		}
+		w.prefixed(n, prefix, strings.Repeat(" ", len(prefix)))
+	case atom.A:
+		sub := htmlTextWriter{pre: w.pre}
+		sub.children(n)
+		t := strings.TrimSpace(sub.String())
+		w.text(t)
+		href := htmlAttr(n, "href")
+		if href != "" && href != t && href != "mailto:"+t {
+			w.text(" <" + href + ">")
+		}
+	case atom.Img:
+		if alt := strings.TrimSpace(htmlAttr(n, "alt")); alt != "" {
+			w.text("[" + alt + "]")
+		} else {
+			w.text("[image]")
+		}
+	default:
+		w.children(n)
+	}
+}
+
+// partCreator starts a MIME part with a content-type and optional
+// content-transfer-encoding, and returns a writer for its body. The part is either
+// the message itself, or a part in a multipart.
+type partCreator func(ct, cte string) io.Writer
+
+// xmessagePart returns a partCreator for the body of the message itself.
+func xmessagePart(xc *message.Composer) partCreator {
+	return func(ct, cte string) io.Writer {
+		xc.Header("Content-Type", ct)
+		if cte != "" {
+			xc.Header("Content-Transfer-Encoding", cte)
+		}
+		xc.Line()
+		return xc
+	}
+}
+
+// xmultipartPart returns a partCreator for parts in mp.
+func xmultipartPart(ctx context.Context, mp *multipart.Writer) partCreator {
+	return func(ct, cte string) io.Writer {
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Type", ct)
+		if cte != "" {
+			h.Set("Content-Transfer-Encoding", cte)
+		}
+		p, err := mp.CreatePart(h)
+		xcheckf(ctx, err, "adding part to message")
+		return p
+	}
+}
+
+// xwriteMultipart writes a multipart with media type ct, e.g. "multipart/mixed",
+// and calls fn to add the parts.
+func xwriteMultipart(ctx context.Context, create partCreator, ct string, fn func(mp *multipart.Writer)) {
+	boundary := multipart.NewWriter(io.Discard).Boundary()
+	mp := multipart.NewWriter(create(fmt.Sprintf(`%s; boundary="%s"`, ct, boundary), ""))
+	err := mp.SetBoundary(boundary)
+	xcheckf(ctx, err, "setting multipart boundary")
+	fn(mp)
+	err = mp.Close()
+	xcheckf(ctx, err, "writing mime multipart")
+}
+
+// xwriteTextPart writes a text part with subtype, e.g. "plain" or "html".
+func xwriteTextPart(ctx context.Context, xc *message.Composer, create partCreator, subtype, text string) {
+	body, ct, cte := xc.TextPart(subtype, text)
+	_, err := create(ct, cte).Write(body)
+	xcheckf(ctx, err, "writing text part")
+}
+
+// xwriteBody writes the text of a message. Without HTML, it is a single
+// text/plain part. With HTML, it is a multipart/alternative with the text and HTML
+// versions. If related is not nil, the HTML version is in a multipart/related,
+// and related is called to add the inline images referenced by the HTML.
+func xwriteBody(ctx context.Context, xc *message.Composer, create partCreator, textBody, htmlBody string, related func(mp *multipart.Writer)) {
+	if htmlBody == "" {
+		xwriteTextPart(ctx, xc, create, "plain", textBody)
+		return
+	}
+	xwriteMultipart(ctx, create, "multipart/alternative", func(mp *multipart.Writer) {
+		xwriteTextPart(ctx, xc, xmultipartPart(ctx, mp), "plain", textBody)
+		if related == nil {
+			xwriteTextPart(ctx, xc, xmultipartPart(ctx, mp), "html", htmlBody)
+			return
+		}
+		xwriteMultipart(ctx, xmultipartPart(ctx, mp), `multipart/related; type="text/html"`, func(rmp *multipart.Writer) {
+			xwriteTextPart(ctx, xc, xmultipartPart(ctx, rmp), "html", htmlBody)
+			related(rmp)
+		})
+	})
+}
@@ -0,0 +1,47 @@
+package webmail
+
+import (
+	"testing"
+)
+
+func TestSanitizeHTML(t *testing.T) {
+	keepCID := func(cid string) bool { return cid == "img1@localhost" }
+
+	check := func(s, exp string) {
+		t.Helper()
+		r, err := sanitizeHTML(s, keepCID)
+		tcheck(t, err, "sanitize")
+		tcompare(t, r, exp)
+	}
+
+	check(`<b>bold</b> <i>italic</i>`, `<b>bold</b> <i>italic</i>`)
+	check(`<p style="color: red" onclick="alert(1)">text</p>`, `<p>text</p>`)
+	check(`<script>alert(1)</script><style>p {}</style>text`, `text`)
+	check(`<font face="x">text</font><!-- comment -->`, `text`)
+	check(`<a href="https://example.org/">link</a>`, `<a href="https://example.org/">link</a>`)
+	check(`<a href="javascript:alert(1)">link</a>`, `<a>link</a>`)
+	check(`<a href=" mailto:mjl@mox.example">mail</a>`, `<a href="mailto:mjl@mox.example">mail</a>`)
+	check(`<img src="cid:img1@localhost" alt="x" width="100" height="1e9">`, `<img src="cid:img1@localhost" alt="x" width="100"/>`)
+	check(`<img src="cid:other@localhost"><img src="https://example.org/track.png">`, ``)
+	check(`<svg><a href="https://example.org/">x</a></svg><iframe src="https://example.org/"></iframe>`, ``)
+	check(`<table><tr><td colspan="2" bgcolor="red">cell</td></tr></table>`, `<table><tbody><tr><td colspan="2">cell</td></tr></tbody></table>`)
+	check(`<div><form action="https://example.org/"><input name="x">text</form></div>`, `<div>text</div>`)
+}
+
+func TestHTMLText(t *testing.T) {
+	check := func(s, exp string) {
+		t.Helper()
+		tcompare(t, htmlText(s), exp)
+	}
+
+	check(`hi <b>there</b>`, "hi there\n")
+	check(`<p>first   paragraph</p><p>second<br>line</p>`, "first paragraph\n\nsecond\nline\n")
+	check(`<div>one</div><div>two</div>`, "one\ntwo\n")
+	check(`<ul><li>a</li><li>b<ul><li>c</li></ul></li></ul>`, "- a\n- b\n  - c\n")
+	check(`<ol><li>a</li><li>b</li></ol>`, "1. a\n2. b\n")
+	check(`text<blockquote><p>quoted</p><p>more</p></blockquote>after`, "text\n> quoted\n>\n> more\nafter\n")
+	check(`<a href="https://example.org/">site</a> <a href="https://example.org/">https://example.org/</a>`, "site <https://example.org/> https://example.org/\n")
+	check(`<img src="cid:x" alt="logo">`, "[logo]\n")
+	check(`<pre>  keep
+  spacing</pre>`, "  keep\n  spacing\n")
+}
@@ -12,6 +12,7 @@ import (
 	"golang.org/x/text/encoding/ianaindex"
 
 	"github.com/mjl-/mox/dns"
+	"github.com/mjl-/mox/ical"
 	"github.com/mjl-/mox/message"
 	"github.com/mjl-/mox/mlog"
 	"github.com/mjl-/mox/mox-"
@@ -77,8 +78,8 @@ func messageItem(log mlog.Log, m store.Message, state *msgState) (MessageItem, e
 		return MessageItem{}, fmt.Errorf("parsing message %d for item: %v", m.ID, err)
 	}
 	// Clear largish unused data.
-	m.MsgPrefix = nil
-	m.ParsedBuf = nil
+	m.Sealed.MsgPrefix = nil
+	m.Sealed.ParsedBuf = nil
 	return MessageItem{m, pm.envelope, pm.attachments, pm.isSigned, pm.isEncrypted, pm.firstLine, true}, nil
 }
 
@@ -355,12 +356,70 @@ func parsedMessage(log mlog.Log, m store.Message, state *msgState, full, msgitem
 	}
 	usePart(*state.part, -1, nil, []int{})
 
+	if full && rerr == nil {
+		if p, path, ok := findCalendarPart(*state.part, []int{}); ok {
+			if cal, err := ical.Parse(&moxio.LimitReader{R: p.ReaderUTF8OrBinary(), Limit: 1024 * 1024}); err != nil {
+				log.Debugx("parsing text/calendar part", err, slog.Int64("msgid", m.ID))
+			} else {
+				pm.Invite = parseInvite(cal, path)
+			}
+		}
+	}
+
 	if rerr == nil {
 		pm.ID = m.ID
 	}
 	return
 }
 
+// findCalendarPart returns the first text/calendar part, and its path.
+func findCalendarPart(p message.Part, path []int) (message.Part, []int, bool) {
+	if p.MediaType == "TEXT" && p.MediaSubType == "CALENDAR" {
+		return p, path, true
+	}
+	for i, sp := range p.Parts {
+		if cp, cpath, ok := findCalendarPart(sp, append(append([]int{}, path...), i)); ok {
+			return cp, cpath, true
+		}
+	}
+	return message.Part{}, nil, false
+}
+
+// parseInvite returns the details of the first event in a calendar object from a
+// message. Nil is returned if there is no event.
+func parseInvite(cal ical.Component, path []int) *Invite {
+	evs := cal.Events()
+	if len(evs) == 0 {
+		return nil
+	}
+	ev := evs[0]
+	attendee := func(p ical.Prop) InviteAttendee {
+		return InviteAttendee{p.Param("CN"), p.Address(), strings.ToUpper(p.Param("PARTSTAT"))}
+	}
+	inv := &Invite{
+		Path:        path,
+		Method:      strings.ToUpper(cal.Text("METHOD")),
+		UID:         ev.Text("UID"),
+		Summary:     ev.Text("SUMMARY"),
+		Location:    ev.Text("LOCATION"),
+		Description: ev.Text("DESCRIPTION"),
+		Attendees:   []InviteAttendee{},
+	}
+	if p, ok := ev.Get("DTSTART"); ok {
+		inv.Start, inv.AllDay, _ = p.Time(cal)
+	}
+	if p, ok := ev.Get("DTEND"); ok {
+		inv.End, _, _ = p.Time(cal)
+	}
+	if p, ok := ev.Get("ORGANIZER"); ok {
+		inv.Organizer = attendee(p)
+	}
+	for _, p := range ev.All("ATTENDEE") {
+		inv.Attendees = append(inv.Attendees, attendee(p))
+	}
+	return inv
+}
+
 // parses List-Post header, returning an address if it could be found, and nil otherwise.
 func parseListPostAddress(s string) *MessageAddress {
 	/*
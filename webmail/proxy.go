@@ -0,0 +1,462 @@
+package webmail
+
+// Proxy for remote content in HTML messages. When enabled, the HTML returned for
+// viewing a message with external resources has the URLs of images, media, styles
+// and fonts rewritten to the proxy endpoint. The proxy fetches the resources, so
+// the browser of the reader doesn't connect to the sender. The CSP for such HTML
+// only allows loading from our own origin, so URLs we don't rewrite aren't loaded
+// at all.
+//
+// The proxy URLs are signed, with a key that only lives in memory. Browsers may
+// not send cookies for requests from the sandboxed iframe the message is rendered
+// in, so we don't authenticate with the session.
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/hmac"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/html"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/moxvar"
+)
+
+// Key for signing proxy URLs. Generated at startup, proxy URLs don't survive restarts.
+var proxyKey = func() []byte {
+	buf := make([]byte, 32)
+	if _, err := cryptorand.Read(buf); err != nil {
+		panic(fmt.Sprintf("generating proxy key: %v", err))
+	}
+	return buf
+}()
+
+// How long a proxy URL can be used after rendering the HTML that contains it.
+const proxyURLValidity = 24 * time.Hour
+
+// Transparent 1x1 gif, served instead of tracking pixels.
+var transparentGIF = []byte("GIF89a\x01\x00\x01\x00\x80\x00\x00\x00\x00\x00\xff\xff\xff!\xf9\x04\x01\x00\x00\x00\x00,\x00\x00\x00\x00\x01\x00\x01\x00\x00\x02\x02D\x01\x00;")
+
+var transparentGIFDataURI = "data:image/gif;base64," + base64.StdEncoding.EncodeToString(transparentGIF)
+
+func proxyEnabled() bool {
+	return mox.Conf.Static.WebmailRemoteContentProxy.Enabled
+}
+
+// proxyConfig returns the configured limits, with defaults applied.
+func proxyConfig() (maxSize, cacheSize int64, cacheTime time.Duration) {
+	c := mox.Conf.Static.WebmailRemoteContentProxy
+	maxSize, cacheSize, cacheTime = c.MaxSize, c.CacheSize, c.CacheTime
+	if maxSize == 0 {
+		maxSize = 5 * 1024 * 1024
+	}
+	if cacheSize == 0 {
+		cacheSize = 64 * 1024 * 1024
+	}
+	if cacheTime == 0 {
+		cacheTime = 24 * time.Hour
+	}
+	return
+}
+
+func proxySign(u string, expires int64) string {
+	mac := hmac.New(sha256.New, proxyKey)
+	fmt.Fprintf(mac, "%d\n%s", expires, u)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// proxyURL returns a signed URL for fetching u through the proxy, relative to
+// prefix, the path to the webmail root.
+func proxyURL(prefix, u string) string {
+	exp := time.Now().Add(proxyURLValidity).Unix()
+	qs := url.Values{"u": {u}, "e": {strconv.FormatInt(exp, 10)}, "s": {proxySign(u, exp)}}
+	return prefix + "proxy?" + qs.Encode()
+}
+
+// proxyRewriter rewrites URLs of resources to proxy URLs.
+type proxyRewriter struct {
+	base   *url.URL // For resolving relative URLs.
+	prefix string   // Path to webmail root, relative to the document.
+}
+
+// url returns the proxy URL for s. Data URIs are returned unchanged. If s isn't an
+// http or https URL, false is returned and the URL should be removed.
+func (pr proxyRewriter) url(s string) (string, bool) {
+	s = strings.TrimSpace(s)
+	if caselessPrefix(s, "data:") {
+		return s, true
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", false
+	}
+	if pr.base != nil {
+		u = pr.base.ResolveReference(u)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return "", false
+	}
+	u.Fragment = ""
+	return proxyURL(pr.prefix, u.String()), true
+}
+
+// srcset rewrites the URLs in an srcset attribute: candidates separated by comma,
+// each with a URL and optional descriptor.
+func (pr proxyRewriter) srcset(s string) string {
+	var l []string
+	for _, c := range strings.Split(s, ",") {
+		t := strings.Fields(c)
+		if len(t) == 0 {
+			continue
+		}
+		if u, ok := pr.url(t[0]); ok {
+			t[0] = u
+			l = append(l, strings.Join(t, " "))
+		}
+	}
+	return strings.Join(l, ", ")
+}
+
+var cssURLRegexp = regexp.MustCompile(`(?i)url\(\s*(?:"([^"]*)"|'([^']*)'|([^'"()\s]*))\s*\)`)
+var cssImportRegexp = regexp.MustCompile(`(?i)@import\s+(?:"([^"]*)"|'([^']*)')`)
+
+// css rewrites the URLs in url() and @import in CSS.
+func (pr proxyRewriter) css(s string) string {
+	s = cssURLRegexp.ReplaceAllStringFunc(s, func(m string) string {
+		t := cssURLRegexp.FindStringSubmatch(m)
+		u, _ := pr.url(t[1] + t[2] + t[3])
+		return `url("` + u + `")`
+	})
+	return cssImportRegexp.ReplaceAllStringFunc(s, func(m string) string {
+		t := cssImportRegexp.FindStringSubmatch(m)
+		u, _ := pr.url(t[1] + t[2])
+		return `@import "` + u + `"`
+	})
+}
+
+var cssDimensionRegexp = regexp.MustCompile(`(?i)(?:^|;)\s*(width|height)\s*:\s*([0-9.]+)px`)
+
+// isTrackingPixel returns whether img element node is likely a tracking pixel:
+// an image that is at most 2x2 pixels, or not displayed.
+func isTrackingPixel(node *html.Node) bool {
+	var width, height float64 = -1, -1
+	for _, a := range node.Attr {
+		switch a.Key {
+		case "width", "height":
+			v, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(a.Val), "px"), 64)
+			if err != nil {
+				continue
+			}
+			if a.Key == "width" {
+				width = v
+			} else {
+				height = v
+			}
+		case "style":
+			if strings.Contains(strings.ReplaceAll(strings.ToLower(a.Val), " ", ""), "display:none") {
+				return true
+			}
+			for _, t := range cssDimensionRegexp.FindAllStringSubmatch(a.Val, -1) {
+				v, err := strconv.ParseFloat(t[2], 64)
+				if err != nil {
+					continue
+				}
+				if strings.EqualFold(t[1], "width") {
+					width = v
+				} else {
+					height = v
+				}
+			}
+		}
+	}
+	return width >= 0 && width <= 2 && height >= 0 && height <= 2
+}
+
+// proxyRewriteHTML rewrites URLs of external resources in the document to proxy
+// URLs, with prefix the path to the webmail root. Tracking pixels are replaced
+// with an inline transparent image. The href of base elements is removed, we
+// resolve relative URLs ourselves.
+func proxyRewriteHTML(doc *html.Node, prefix string) {
+	pr := proxyRewriter{prefix: prefix}
+	var findBase func(n *html.Node) bool
+	findBase = func(n *html.Node) bool {
+		if n.Type == html.ElementNode && n.Data == "base" {
+			for _, a := range n.Attr {
+				if a.Key == "href" && a.Namespace == "" {
+					if u, err := url.Parse(strings.TrimSpace(a.Val)); err == nil && u.IsAbs() {
+						pr.base = u
+						return true
+					}
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if findBase(c) {
+				return true
+			}
+		}
+		return false
+	}
+	findBase(doc)
+	pr.rewriteNode(doc)
+}
+
+func (pr proxyRewriter) rewriteNode(node *html.Node) {
+	if node.Type == html.ElementNode && node.Data == "style" {
+		for c := node.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.TextNode {
+				c.Data = pr.css(c.Data)
+			}
+		}
+	}
+
+	if node.Type == html.ElementNode {
+		tracking := node.Data == "img" && isTrackingPixel(node)
+		var stylesheet bool
+		if node.Data == "link" {
+			for _, a := range node.Attr {
+				if a.Key == "rel" && strings.Contains(strings.ToLower(a.Val), "stylesheet") {
+					stylesheet = true
+				}
+			}
+		}
+		i := 0
+		for i < len(node.Attr) {
+			a := &node.Attr[i]
+			if a.Namespace != "" {
+				i++
+				continue
+			}
+			keep := true
+			switch {
+			case a.Key == "style":
+				a.Val = pr.css(a.Val)
+			case a.Key == "srcset":
+				a.Val = pr.srcset(a.Val)
+				keep = !tracking && a.Val != ""
+			case tracking && a.Key == "src":
+				a.Val = transparentGIFDataURI
+			case a.Key == "src" || a.Key == "poster" || a.Key == "background":
+				a.Val, keep = pr.url(a.Val)
+			case a.Key == "href" && node.Data == "base":
+				keep = false
+			case a.Key == "href" && stylesheet:
+				a.Val, keep = pr.url(a.Val)
+			}
+			if !keep {
+				node.Attr = append(node.Attr[:i], node.Attr[i+1:]...)
+				continue
+			}
+			i++
+		}
+	}
+
+	for c := node.FirstChild; c != nil; c = c.NextSibling {
+		pr.rewriteNode(c)
+	}
+}
+
+var proxyClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext:           (&net.Dialer{Timeout: 10 * time.Second, Control: mox.PublicDialControl}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 20 * time.Second,
+		MaxIdleConns:          20,
+		IdleConnTimeout:       time.Minute,
+	},
+	Timeout: 30 * time.Second,
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 5 {
+			return errors.New("too many redirects")
+		}
+		if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+			return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+		}
+		return nil
+	},
+}
+
+// proxyEntry is a fetched resource.
+type proxyEntry struct {
+	url         string
+	contentType string
+	data        []byte
+	fetched     time.Time
+}
+
+// Cache of fetched resources, shared between accounts, evicting least recently
+// used entries.
+var proxyCache = struct {
+	sync.Mutex
+	lru     *list.List // Of *proxyEntry, most recently used at front.
+	entries map[string]*list.Element
+	size    int64
+}{lru: list.New(), entries: map[string]*list.Element{}}
+
+func proxyCacheGet(u string, maxAge time.Duration) *proxyEntry {
+	proxyCache.Lock()
+	defer proxyCache.Unlock()
+	e, ok := proxyCache.entries[u]
+	if !ok {
+		return nil
+	}
+	pe := e.Value.(*proxyEntry)
+	if time.Since(pe.fetched) > maxAge {
+		proxyCache.lru.Remove(e)
+		delete(proxyCache.entries, u)
+		proxyCache.size -= int64(len(pe.data))
+		return nil
+	}
+	proxyCache.lru.MoveToFront(e)
+	return pe
+}
+
+func proxyCacheAdd(pe *proxyEntry, maxSize int64) {
+	proxyCache.Lock()
+	defer proxyCache.Unlock()
+	if e, ok := proxyCache.entries[pe.url]; ok {
+		proxyCache.size -= int64(len(e.Value.(*proxyEntry).data))
+		proxyCache.lru.Remove(e)
+	}
+	proxyCache.entries[pe.url] = proxyCache.lru.PushFront(pe)
+	proxyCache.size += int64(len(pe.data))
+	for proxyCache.size > maxSize {
+		e := proxyCache.lru.Back()
+		old := e.Value.(*proxyEntry)
+		proxyCache.lru.Remove(e)
+		delete(proxyCache.entries, old.url)
+		proxyCache.size -= int64(len(old.data))
+	}
+}
+
+// proxyContentTypeAllowed returns whether media type mt can be served through the
+// proxy: images, styles, fonts and media.
+func proxyContentTypeAllowed(mt string) bool {
+	t, _, _ := strings.Cut(mt, "/")
+	switch t {
+	case "image", "font", "audio", "video":
+		return true
+	}
+	switch mt {
+	case "text/css", "application/font-woff", "application/font-woff2", "application/x-font-ttf", "application/x-font-otf", "application/vnd.ms-fontobject":
+		return true
+	}
+	return false
+}
+
+// proxyFetch returns the resource at u, from the cache or fetched.
+func proxyFetch(ctx context.Context, log mlog.Log, u string) (*proxyEntry, error) {
+	maxSize, cacheSize, cacheTime := proxyConfig()
+	if pe := proxyCacheGet(u, cacheTime); pe != nil {
+		return pe, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("new request: %v", err)
+	}
+	// No cookies and no referrer, and a user-agent that doesn't identify the reader.
+	req.Header.Set("User-Agent", fmt.Sprintf("mox/%s (webmail proxy)", moxvar.Version))
+	req.Header.Set("Accept", "image/*, text/css, font/*, audio/*, video/*;q=0.9, */*;q=0.1")
+	resp, err := proxyClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("http transaction: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("remote server responded with status %d", resp.StatusCode)
+	}
+	mt, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || !proxyContentTypeAllowed(mt) {
+		return nil, fmt.Errorf("content-type %q not allowed", resp.Header.Get("Content-Type"))
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("reading response: %v", err)
+	} else if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("resource larger than maximum size %d", maxSize)
+	}
+
+	// Tracking pixels that weren't recognized from the HTML attributes.
+	if strings.HasPrefix(mt, "image/") {
+		if ic, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil && ic.Width <= 2 && ic.Height <= 2 {
+			log.Debug("replacing tracking pixel with transparent image", slog.String("url", u))
+			mt = "image/gif"
+			data = transparentGIF
+		}
+	}
+
+	pe := &proxyEntry{u, mt, data, time.Now()}
+	if int64(len(data)) <= cacheSize {
+		proxyCacheAdd(pe, cacheSize)
+	}
+	return pe, nil
+}
+
+// serveProxy serves a resource for a signed proxy URL.
+func serveProxy(ctx context.Context, log mlog.Log, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "405 - method not allowed - use get", http.StatusMethodNotAllowed)
+		return
+	}
+	if !proxyEnabled() {
+		http.NotFound(w, r)
+		return
+	}
+
+	q := r.URL.Query()
+	u := q.Get("u")
+	exp, err := strconv.ParseInt(q.Get("e"), 10, 64)
+	if err != nil || !hmac.Equal([]byte(q.Get("s")), []byte(proxySign(u, exp))) {
+		http.Error(w, "403 - forbidden - bad signature", http.StatusForbidden)
+		return
+	} else if time.Now().Unix() > exp {
+		http.Error(w, "403 - forbidden - url expired, reload message", http.StatusForbidden)
+		return
+	}
+
+	pe, err := proxyFetch(ctx, log, u)
+	if err != nil {
+		log.Debugx("fetching remote content for proxy", err, slog.String("url", u))
+		http.Error(w, "502 - bad gateway - fetching remote content failed", http.StatusBadGateway)
+		return
+	}
+	data := pe.data
+	if pe.contentType == "text/css" {
+		// Resources referenced from stylesheets are fetched through the proxy too,
+		// relative to the stylesheet URL. Proxy URLs are relative to this proxy URL.
+		base, _ := url.Parse(u)
+		data = []byte(proxyRewriter{base: base}.css(string(data)))
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", pe.contentType)
+	h.Set("Cache-Control", "private, max-age=3600")
+	h.Set("Content-Security-Policy", "sandbox; default-src 'none'")
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Referrer-Policy", "no-referrer")
+	_, err = w.Write(data)
+	log.Check(err, "writing proxied content")
+}
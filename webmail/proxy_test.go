@@ -0,0 +1,198 @@
+package webmail
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/gif"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+)
+
+// unproxy returns the original URL from a proxy URL, checking the signature.
+func unproxy(t *testing.T, s string) string {
+	t.Helper()
+	_, qs, ok := strings.Cut(s, "proxy?")
+	if !ok {
+		t.Fatalf("not a proxy url: %q", s)
+	}
+	q, err := url.ParseQuery(qs)
+	tcheck(t, err, "parse query")
+	exp, err := strconv.ParseInt(q.Get("e"), 10, 64)
+	tcheck(t, err, "parse expiration")
+	tcompare(t, q.Get("s"), proxySign(q.Get("u"), exp))
+	return q.Get("u")
+}
+
+func TestProxyRewrite(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(`<html><head><base href="https://example.org/news/"><link rel="stylesheet" href="style.css"><link rel="icon" href="/favicon.ico"><style>body { background: url('/bg.png') }</style></head><body background="http://example.org/b.png"><img src="a.png" srcset="a.png 1x, https://cdn.example/a2.png 2x"><img src="https://track.example/p.gif" width="1" height="1"><img src="https://track.example/q.gif" style="display: none"><img src="javascript:evil"><img src="data:image/png;base64,AAAA"><div style="background-image: url(&quot;x.png&quot;)"></div><a href="https://example.org/link">link</a></body></html>`))
+	tcheck(t, err, "parse html")
+	proxyRewriteHTML(doc, "../../")
+
+	var srcs, removed []string
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			attr := map[string]string{}
+			for _, a := range n.Attr {
+				attr[a.Key] = a.Val
+			}
+			switch n.Data {
+			case "base":
+				_, ok := attr["href"]
+				tcompare(t, ok, false)
+			case "img":
+				src, ok := attr["src"]
+				if !ok {
+					removed = append(removed, "img")
+				} else if strings.HasPrefix(src, "../../proxy?") {
+					srcs = append(srcs, unproxy(t, src))
+				} else {
+					srcs = append(srcs, src)
+				}
+				if s, ok := attr["srcset"]; ok {
+					l := strings.Split(s, ", ")
+					tcompare(t, len(l), 2)
+					tcompare(t, unproxy(t, strings.Fields(l[1])[0]), "https://cdn.example/a2.png")
+				}
+			case "link":
+				if attr["rel"] == "stylesheet" {
+					tcompare(t, unproxy(t, attr["href"]), "https://example.org/news/style.css")
+				} else {
+					tcompare(t, attr["href"], "/favicon.ico") // Blocked by CSP.
+				}
+			case "style":
+				tcompare(t, strings.Contains(n.FirstChild.Data, `url("../../proxy?`), true)
+			case "body":
+				tcompare(t, unproxy(t, attr["background"]), "http://example.org/b.png")
+			case "div":
+				_, qs, _ := strings.Cut(attr["style"], `url("`)
+				tcompare(t, unproxy(t, strings.TrimSuffix(qs, `")`)), "https://example.org/news/x.png")
+			case "a":
+				tcompare(t, attr["href"], "https://example.org/link")
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	tcompare(t, srcs, []string{"https://example.org/news/a.png", transparentGIFDataURI, transparentGIFDataURI, "data:image/png;base64,AAAA"})
+	tcompare(t, removed, []string{"img"}) // The javascript: src.
+
+	pr := proxyRewriter{}
+	_, qs, _ := strings.Cut(pr.css(`@import 'https://example.org/x.css';`), `@import "`)
+	tcompare(t, unproxy(t, strings.TrimSuffix(qs, `";`)), "https://example.org/x.css")
+
+	// Transparent image must be valid.
+	img, err := gif.Decode(bytes.NewReader(transparentGIF))
+	tcheck(t, err, "decode gif")
+	tcompare(t, img.Bounds().Dx(), 1)
+}
+
+func TestProxy(t *testing.T) {
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/webmail/mox.conf")
+	mox.ConfigDynamicPath = filepath.FromSlash("../testdata/webmail/domains.conf")
+	mox.MustLoadConfig(true, false)
+	mox.Conf.Static.WebmailRemoteContentProxy.Enabled = true
+	mox.Conf.Static.WebmailRemoteContentProxy.MaxSize = 1024
+	defer func() {
+		mox.Conf.Static.WebmailRemoteContentProxy.Enabled = false
+		mox.Conf.Static.WebmailRemoteContentProxy.MaxSize = 0
+	}()
+	log := mlog.New("webmail", nil)
+
+	// Connections to local IPs are not allowed.
+	_, err := proxyFetch(ctxbg, log, "http://127.0.0.1:1/x.png")
+	if !errors.Is(err, mox.ErrSpecialPurposeIP) {
+		t.Fatalf("got err %v, expected ErrSpecialPurposeIP", err)
+	}
+
+	var tiny bytes.Buffer
+	err = png.Encode(&tiny, image.NewGray(image.Rect(0, 0, 1, 1)))
+	tcheck(t, err, "encode png")
+
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		tcompare(t, r.Header.Get("Cookie"), "")
+		tcompare(t, r.Header.Get("Referer"), "")
+		switch r.URL.Path {
+		case "/tiny.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(tiny.Bytes())
+		case "/large.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(make([]byte, 2000))
+		case "/style.css":
+			w.Header().Set("Content-Type", "text/css")
+			w.Write([]byte(`body { background: url(img/bg.png) }`))
+		case "/page.html":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<html></html>`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	origClient := proxyClient
+	proxyClient = srv.Client()
+	defer func() {
+		proxyClient = origClient
+	}()
+
+	get := func(u string, expCode int) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest("GET", "/"+proxyURL("", u), nil)
+		w := httptest.NewRecorder()
+		serveProxy(ctxbg, log, w, r)
+		tcompare(t, w.Code, expCode)
+		return w
+	}
+
+	// Tracking pixel replaced, and cached.
+	w := get(srv.URL+"/tiny.png", http.StatusOK)
+	tcompare(t, w.Header().Get("Content-Type"), "image/gif")
+	tcompare(t, w.Body.Bytes(), transparentGIF)
+	get(srv.URL+"/tiny.png", http.StatusOK)
+	tcompare(t, requests, 1)
+
+	// Larger than MaxSize.
+	get(srv.URL+"/large.png", http.StatusBadGateway)
+
+	// Not an allowed content-type.
+	get(srv.URL+"/page.html", http.StatusBadGateway)
+	get(srv.URL+"/missing.png", http.StatusBadGateway)
+
+	// URLs in stylesheets go through the proxy too, resolved against the stylesheet URL.
+	w = get(srv.URL+"/style.css", http.StatusOK)
+	_, qs, _ := strings.Cut(w.Body.String(), `url("`)
+	tcompare(t, unproxy(t, strings.TrimSuffix(qs, `") }`)), srv.URL+"/img/bg.png")
+
+	// Bad signature.
+	r := httptest.NewRequest("GET", "/"+strings.Replace(proxyURL("", srv.URL+"/tiny.png"), "tiny", "other", 1), nil)
+	rw := httptest.NewRecorder()
+	serveProxy(ctxbg, log, rw, r)
+	tcompare(t, rw.Code, http.StatusForbidden)
+
+	// Cache evicts least recently used entries.
+	proxyCacheAdd(&proxyEntry{url: "a", data: make([]byte, 60)}, 100)
+	proxyCacheAdd(&proxyEntry{url: "b", data: make([]byte, 60)}, 100)
+	proxyCache.Lock()
+	_, okA := proxyCache.entries["a"]
+	_, okB := proxyCache.entries["b"]
+	proxyCache.Unlock()
+	tcompare(t, okA, false)
+	tcompare(t, okB, true)
+}
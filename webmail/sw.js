@@ -0,0 +1,36 @@
+"use strict";
+// Service worker for webmail, showing notifications for Web Push messages about
+// new messages while webmail is not open. Written in javascript directly: It runs
+// in a worker context, without the DOM and the code shared by the other pages.
+// The payload is a JSON object with fields Title, Body, URL and Tag, see
+// ../webpush/notify.go:/Notification.
+self.addEventListener('push', (e) => {
+	let n = {};
+	try {
+		n = e.data ? e.data.json() : {};
+	}
+	catch (err) {
+		console.log('parsing push message', err);
+	}
+	e.waitUntil(self.registration.showNotification(n.Title || 'New message', {
+		body: n.Body || '',
+		tag: n.Tag || 'newmail',
+		renotify: true,
+		data: { url: new URL(n.URL || '', self.registration.scope).href },
+	}));
+});
+// Focus an open webmail window, or open a new one, when a notification is clicked.
+self.addEventListener('notificationclick', (e) => {
+	e.notification.close();
+	const url = e.notification.data.url;
+	e.waitUntil((async () => {
+		const l = await self.clients.matchAll({ type: 'window', includeUncontrolled: true });
+		for (const c of l) {
+			if (c.url.startsWith(self.registration.scope)) {
+				await c.focus();
+				return;
+			}
+		}
+		await self.clients.openWindow(url);
+	})());
+});
@@ -0,0 +1,107 @@
+package webmail
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/store"
+)
+
+// Maximum size of a single chunk of an upload. Clients send smaller chunks.
+const uploadChunkMax = 4 * 1024 * 1024
+
+// handleUpload handles requests for "/upload/<id>", for uploads started with the
+// UploadStart API call.
+//
+// PUT with query string parameter "offset" stores a chunk of data, which must
+// start at the number of bytes received so far. The response is the JSON-encoded
+// store.Upload. If the offset does not match, e.g. after an interrupted request,
+// the response has status 409 (conflict) and the upload, with the offset to
+// resume at in field Received.
+//
+// GET returns the data of a complete upload of an image, for showing inline
+// images while composing.
+func handleUpload(log mlog.Log, accName string, w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/upload/"), 10, 64)
+	if err != nil || id <= 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != "GET" && r.Method != "PUT" {
+		http.Error(w, "405 - method not allowed - get or put required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	acc, err := store.OpenAccount(log, accName)
+	xcheckf(ctx, err, "open account")
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	if r.Method == "GET" {
+		u := store.Upload{ID: id}
+		err := acc.DB.Get(ctx, &u)
+		if err == bstore.ErrAbsent || err == nil && (!u.Complete() || !strings.HasPrefix(u.ContentType, "image/") || strings.HasPrefix(u.ContentType, "image/svg")) {
+			http.NotFound(w, r)
+			return
+		}
+		xcheckf(ctx, err, "get upload")
+
+		h := w.Header()
+		h.Set("Content-Type", u.ContentType)
+		h.Set("Content-Security-Policy", "sandbox; default-src 'none'")
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("Cache-Control", "no-store, max-age=0")
+		http.ServeFile(w, r, acc.UploadPath(u.ID))
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "400 - bad request - missing or invalid offset", http.StatusBadRequest)
+		return
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, uploadChunkMax))
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		http.Error(w, fmt.Sprintf("413 - request entity too large - chunks can be at most %d bytes", uploadChunkMax), http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		log.Debugx("reading upload chunk", err)
+		http.Error(w, "400 - bad request - error reading data", http.StatusBadRequest)
+		return
+	}
+
+	u, err := acc.UploadWrite(ctx, id, offset, data)
+	status := http.StatusOK
+	if err == bstore.ErrAbsent {
+		http.NotFound(w, r)
+		return
+	} else if errors.Is(err, store.ErrUploadOffset) {
+		status = http.StatusConflict
+	} else if errors.Is(err, store.ErrUploadSize) {
+		http.Error(w, "400 - bad request - "+err.Error(), http.StatusBadRequest)
+		return
+	} else {
+		xcheckf(ctx, err, "storing upload data")
+	}
+	log.Debug("upload chunk", slog.Int64("uploadid", id), slog.Int64("offset", offset), slog.Int("size", len(data)), slog.Int("status", status))
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	err = json.NewEncoder(w).Encode(u)
+	log.Check(err, "writing upload response")
+}
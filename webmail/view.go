@@ -24,6 +24,7 @@ import (
 	"github.com/mjl-/bstore"
 	"github.com/mjl-/sherpa"
 
+	"github.com/mjl-/mox/config"
 	"github.com/mjl-/mox/dns"
 	"github.com/mjl-/mox/message"
 	"github.com/mjl-/mox/metrics"
@@ -197,6 +198,9 @@ type ParsedMessage struct {
 
 	ListReplyAddress *MessageAddress // From List-Post.
 
+	// Calendar invitation, from the first text/calendar part, if any.
+	Invite *Invite
+
 	// Information used by MessageItem, not exported in this type.
 	envelope    MessageEnvelope
 	attachments []Attachment
@@ -205,6 +209,29 @@ type ParsedMessage struct {
 	firstLine   string
 }
 
+// Invite is a calendar invitation (iMIP, RFC 6047), or a reply or cancellation
+// for an event.
+type Invite struct {
+	Path        []int  // Path to the text/calendar part in the message.
+	Method      string // E.g. "REQUEST", "REPLY" or "CANCEL".
+	UID         string
+	Summary     string
+	Location    string
+	Description string
+	Start       time.Time
+	End         time.Time // Zero if absent.
+	AllDay      bool      // If set, Start and End are dates without time, and End is exclusive.
+	Organizer   InviteAttendee
+	Attendees   []InviteAttendee
+}
+
+// InviteAttendee is an organizer or attendee of an event.
+type InviteAttendee struct {
+	Name   string
+	Email  string
+	Status string // Participation status, e.g. "NEEDS-ACTION", "ACCEPTED", "TENTATIVE", "DECLINED".
+}
+
 // EventStart is the first message sent on an SSE connection, giving the client
 // basic data to populate its UI. After this event, messages will follow quickly in
 // an EventViewMsgs event.
@@ -217,7 +244,8 @@ type EventStart struct {
 	Mailboxes            []store.Mailbox
 	RejectsMailbox       string
 	Settings             store.Settings
-	AccountPath          string // If nonempty, the path on same host to webaccount interface.
+	Identities           []store.Identity // Settings per From address for composing messages.
+	AccountPath          string           // If nonempty, the path on same host to webaccount interface.
 	Version              string
 }
 
@@ -412,14 +440,51 @@ func sseGet(id int64, accountName string) (sse, bool) {
 	return s, true
 }
 
+// xaccountAddresses returns the addresses of an account the webmail client can
+// use as From address.
+func xaccountAddresses(ctx context.Context, accConf config.Account) []MessageAddress {
+	var addresses []MessageAddress
+	for a, dest := range accConf.Destinations {
+		name := dest.FullName
+		if name == "" {
+			name = accConf.FullName
+		}
+		var ma MessageAddress
+		if strings.HasPrefix(a, "@") {
+			dom, err := dns.ParseDomain(a[1:])
+			xcheckf(ctx, err, "parsing destination address for account")
+			ma = MessageAddress{Domain: dom}
+		} else {
+			addr, err := smtp.ParseAddress(a)
+			xcheckf(ctx, err, "parsing destination address for account")
+			ma = MessageAddress{Name: name, User: addr.Localpart.String(), Domain: addr.Domain}
+		}
+		addresses = append(addresses, ma)
+	}
+	// User is allowed to send using alias address as message From address. Webmail
+	// will choose it when replying to a message sent to that address.
+	aliasAddrs := map[MessageAddress]bool{}
+	for _, a := range accConf.Aliases {
+		if a.Alias.AllowMsgFrom {
+			ma := MessageAddress{User: a.Alias.LocalpartStr, Domain: a.Alias.Domain}
+			if !aliasAddrs[ma] {
+				addresses = append(addresses, ma)
+			}
+			aliasAddrs[ma] = true
+		}
+	}
+	return addresses
+}
+
 // ssetoken is a temporary token that has not yet been used to start an SSE
 // connection. Created by Token, consumed by a new SSE connection.
 type ssetoken struct {
-	token        string // Uniquely generated.
-	accName      string
-	address      string             // Address used to authenticate in call that created the token.
-	sessionToken store.SessionToken // SessionToken that created this token, checked before sending updates.
-	validUntil   time.Time
+	token          string // Uniquely generated.
+	accName        string
+	address        string             // Address used to authenticate in call that created the token.
+	sessionAccName string             // Account of the session, different from accName for a linked account.
+	sessionToken   store.SessionToken // SessionToken that created this token, checked before sending updates.
+	validUntil     time.Time
 }
 
 // ssetokens maintains unused tokens. We have just one, but it's a type so we
@@ -437,11 +502,11 @@ var sseTokens = ssetokens{
 
 // xgenerate creates and saves a new token. It ensures no more than 10 tokens
 // per account exist, removing old ones if needed.
-func (x *ssetokens) xgenerate(ctx context.Context, accName, address string, sessionToken store.SessionToken) string {
+func (x *ssetokens) xgenerate(ctx context.Context, accName, address, sessionAccName string, sessionToken store.SessionToken) string {
 	buf := make([]byte, 16)
 	_, err := cryptrand.Read(buf)
 	xcheckf(ctx, err, "generating token")
-	st := ssetoken{base64.RawURLEncoding.EncodeToString(buf), accName, address, sessionToken, time.Now().Add(time.Minute)}
+	st := ssetoken{base64.RawURLEncoding.EncodeToString(buf), accName, address, sessionAccName, sessionToken, time.Now().Add(time.Minute)}
 
 	x.Lock()
 	defer x.Unlock()
@@ -459,17 +524,17 @@ func (x *ssetokens) xgenerate(ctx context.Context, accName, address string, sess
 }
 
 // check verifies a token, and consumes it if valid.
-func (x *ssetokens) check(token string) (string, string, store.SessionToken, bool, error) {
+func (x *ssetokens) check(token string) (string, string, string, store.SessionToken, bool, error) {
 	x.Lock()
 	defer x.Unlock()
 
 	st, ok := x.tokens[token]
 	if !ok {
-		return "", "", "", false, nil
+		return "", "", "", "", false, nil
 	}
 	delete(x.tokens, token)
 	if i := slices.Index(x.accountTokens[st.accName], st); i < 0 {
-		return "", "", "", false, errors.New("internal error, could not find token in account")
+		return "", "", "", "", false, errors.New("internal error, could not find token in account")
 	} else {
 		copy(x.accountTokens[st.accName][i:], x.accountTokens[st.accName][i+1:])
 		x.accountTokens[st.accName] = x.accountTokens[st.accName][:len(x.accountTokens[st.accName])-1]
@@ -478,9 +543,9 @@ func (x *ssetokens) check(token string) (string, string, store.SessionToken, boo
 		}
 	}
 	if time.Now().After(st.validUntil) {
-		return "", "", "", false, nil
+		return "", "", "", "", false, nil
 	}
-	return st.accName, st.address, st.sessionToken, true, nil
+	return st.accName, st.address, st.sessionAccName, st.sessionToken, true, nil
 }
 
 // ioErr is panicked on i/o errors in serveEvents and handled in a defer.
@@ -509,7 +574,7 @@ func serveEvents(ctx context.Context, log mlog.Log, accountPath string, w http.R
 		http.Error(w, "400 - bad request - missing credentials", http.StatusBadRequest)
 		return
 	}
-	accName, address, sessionToken, ok, err := sseTokens.check(token)
+	accName, address, sessionAccName, sessionToken, ok, err := sseTokens.check(token)
 	if err != nil {
 		http.Error(w, "500 - internal server error - "+err.Error(), http.StatusInternalServerError)
 		return
@@ -518,7 +583,7 @@ func serveEvents(ctx context.Context, log mlog.Log, accountPath string, w http.R
 		http.Error(w, "400 - bad request - bad token", http.StatusBadRequest)
 		return
 	}
-	if _, err := store.SessionUse(ctx, log, accName, sessionToken, ""); err != nil {
+	if _, err := store.SessionUse(ctx, log, sessionAccName, sessionToken, ""); err != nil {
 		http.Error(w, "400 - bad request - bad session token", http.StatusBadRequest)
 		return
 	}
@@ -601,7 +666,7 @@ func serveEvents(ctx context.Context, log mlog.Log, accountPath string, w http.R
 	out = httpFlusher{out, flusher}
 
 	// We'll be writing outgoing SSE events through writer.
-	writer = newEventWriter(out, waitMin, waitMax, accName, sessionToken)
+	writer = newEventWriter(out, waitMin, waitMax, sessionAccName, sessionToken)
 	defer writer.close()
 
 	// Fetch initial data.
@@ -625,36 +690,7 @@ func serveEvents(ctx context.Context, log mlog.Log, accountPath string, w http.R
 		loginName = dest.FullName
 	}
 	loginAddress := MessageAddress{Name: loginName, User: loginAddr.Localpart.String(), Domain: loginAddr.Domain}
-	var addresses []MessageAddress
-	for a, dest := range accConf.Destinations {
-		name := dest.FullName
-		if name == "" {
-			name = accConf.FullName
-		}
-		var ma MessageAddress
-		if strings.HasPrefix(a, "@") {
-			dom, err := dns.ParseDomain(a[1:])
-			xcheckf(ctx, err, "parsing destination address for account")
-			ma = MessageAddress{Domain: dom}
-		} else {
-			addr, err := smtp.ParseAddress(a)
-			xcheckf(ctx, err, "parsing destination address for account")
-			ma = MessageAddress{Name: name, User: addr.Localpart.String(), Domain: addr.Domain}
-		}
-		addresses = append(addresses, ma)
-	}
-	// User is allowed to send using alias address as message From address. Webmail
-	// will choose it when replying to a message sent to that address.
-	aliasAddrs := map[MessageAddress]bool{}
-	for _, a := range accConf.Aliases {
-		if a.Alias.AllowMsgFrom {
-			ma := MessageAddress{User: a.Alias.LocalpartStr, Domain: a.Alias.Domain}
-			if !aliasAddrs[ma] {
-				addresses = append(addresses, ma)
-			}
-			aliasAddrs[ma] = true
-		}
-	}
+	addresses := xaccountAddresses(ctx, accConf)
 
 	// We implicitly start a query. We use the reqctx for the transaction, because the
 	// transaction is passed to the query, which can be canceled.
@@ -679,6 +715,7 @@ func serveEvents(ctx context.Context, log mlog.Log, accountPath string, w http.R
 	}()
 
 	var mbl []store.Mailbox
+	var identities []store.Identity
 	settings := store.Settings{ID: 1}
 
 	// We only take the rlock when getting the tx.
@@ -692,6 +729,9 @@ func serveEvents(ctx context.Context, log mlog.Log, accountPath string, w http.R
 
 		err = qtx.Get(&settings)
 		xcheckf(ctx, err, "get settings")
+
+		identities, err = bstore.QueryTx[store.Identity](qtx).SortAsc("Address").List()
+		xcheckf(ctx, err, "list identities")
 	})
 
 	// Find the designated mailbox if a mailbox name is set, or there are no filters at all.
@@ -747,7 +787,7 @@ func serveEvents(ctx context.Context, log mlog.Log, accountPath string, w http.R
 	}
 
 	// Write first event, allowing client to fill its UI with mailboxes.
-	start := EventStart{sse.ID, loginAddress, addresses, domainAddressConfigs, mailbox.Name, mbl, accConf.RejectsMailbox, settings, accountPath, moxvar.Version}
+	start := EventStart{sse.ID, loginAddress, addresses, domainAddressConfigs, mailbox.Name, mbl, accConf.RejectsMailbox, settings, identities, accountPath, moxvar.Version}
 	writer.xsendEvent(ctx, log, "start", start)
 
 	// The goroutine doing the querying will send messages on these channels, which
@@ -1672,12 +1712,12 @@ func (ms *msgState) ensurePart(m store.Message, withMsgReader bool) bool {
 
 	if ms.err == nil {
 		if ms.part == nil {
-			if m.ParsedBuf == nil {
+			if m.Sealed.ParsedBuf == nil {
 				ms.err = fmt.Errorf("message %d not parsed", m.ID)
 				return false
 			}
 			var p message.Part
-			if err := json.Unmarshal(m.ParsedBuf, &p); err != nil {
+			if err := json.Unmarshal(m.Sealed.ParsedBuf, &p); err != nil {
 				ms.err = fmt.Errorf("load part for message %d: %w", m.ID, err)
 				return false
 			}
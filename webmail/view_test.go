@@ -45,7 +45,7 @@ func TestView(t *testing.T) {
 	api := Webmail{maxMessageSize: 1024 * 1024, cookiePath: "/"}
 
 	respRec := httptest.NewRecorder()
-	reqInfo := requestInfo{log, "mjl@mox.example", acc, "", respRec, &http.Request{RemoteAddr: "127.0.0.1:1234"}}
+	reqInfo := requestInfo{log, "mjl@mox.example", acc, "", "mjl", respRec, &http.Request{RemoteAddr: "127.0.0.1:1234"}}
 	ctx := context.WithValue(ctxbg, requestInfoCtxKey, reqInfo)
 
 	// Prepare loginToken.
@@ -53,7 +53,7 @@ func TestView(t *testing.T) {
 	loginCookie.Value = api.LoginPrep(ctx)
 	reqInfo.Request.Header = http.Header{"Cookie": []string{loginCookie.String()}}
 
-	api.Login(ctx, loginCookie.Value, "mjl@mox.example", "test1234")
+	api.Login(ctx, loginCookie.Value, "mjl@mox.example", "test1234", "")
 	var sessionCookie *http.Cookie
 	for _, c := range respRec.Result().Cookies() {
 		if c.Name == "webmailsession" {
@@ -70,7 +70,7 @@ func TestView(t *testing.T) {
 	}
 	sessionToken := store.SessionToken(sct[0])
 
-	reqInfo = requestInfo{log, "mjl@mox.example", acc, sessionToken, respRec, &http.Request{}}
+	reqInfo = requestInfo{log, "mjl@mox.example", acc, sessionToken, "mjl", respRec, &http.Request{}}
 	ctx = context.WithValue(ctxbg, requestInfoCtxKey, reqInfo)
 
 	api.MailboxCreate(ctx, "Lists/Go/Nuts")
@@ -428,7 +428,7 @@ func TestView(t *testing.T) {
 		ChangeMailboxKeywords: store.ChangeMailboxKeywords{
 			MailboxID:   inbox.ID,
 			MailboxName: inbox.Name,
-			Keywords:    []string{`aaa`, `changelabel`},
+			Keywords:    []string{`aaa`, `changelabel`, `testlabel`}, // testlabel from delivery of inboxFlags.
 		},
 	})
 	chmbcounts.Size = 0
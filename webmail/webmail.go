@@ -32,6 +32,7 @@ import (
 	"github.com/mjl-/bstore"
 	"github.com/mjl-/sherpa"
 
+	"github.com/mjl-/mox/i18n"
 	"github.com/mjl-/mox/message"
 	"github.com/mjl-/mox/metrics"
 	"github.com/mjl-/mox/mlog"
@@ -52,12 +53,13 @@ type ctxKey string
 var requestInfoCtxKey ctxKey = "requestInfo"
 
 type requestInfo struct {
-	Log          mlog.Log
-	LoginAddress string
-	Account      *store.Account // Nil only for methods Login and LoginPrep.
-	SessionToken store.SessionToken
-	Response     http.ResponseWriter
-	Request      *http.Request // For Proto and TLS connection state during message submit.
+	Log                mlog.Log
+	LoginAddress       string
+	Account            *store.Account // Nil only for methods Login and LoginPrep.
+	SessionToken       store.SessionToken
+	SessionAccountName string // Account of the session, Account is different if a linked account is active.
+	Response           http.ResponseWriter
+	Request            *http.Request // For Proto and TLS connection state during message submit.
 }
 
 //go:embed webmail.html
@@ -78,12 +80,15 @@ var webmailtextHTML []byte
 //go:embed text.js
 var webmailtextJS []byte
 
+//go:embed sw.js
+var webmailswJS []byte
+
 var (
 	// Similar between ../webmail/webmail.go:/metricSubmission and ../smtpserver/server.go:/metricSubmission and ../webapisrv/server.go:/metricSubmission
 	metricSubmission = promauto.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "mox_webmail_submission_total",
-			Help: "Webmail message submission results, known values (those ending with error are server errors): ok, badfrom, messagelimiterror, recipientlimiterror, queueerror, storesenterror.",
+			Help: "Webmail message submission results, known values (those ending with error are server errors): ok, badfrom, messagelimiterror, recipientlimiterror, guardrefused, queueerror, storesenterror.",
 		},
 		[]string{
 			"result",
@@ -92,7 +97,7 @@ var (
 	metricServerErrors = promauto.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "mox_webmail_errors_total",
-			Help: "Webmail server errors, known values: dkimsign, submit.",
+			Help: "Webmail server errors, known values: dkimsign, submit, footer.",
 		},
 		[]string{
 			"error",
@@ -229,7 +234,16 @@ func handle(apiHandler http.Handler, isForwarded bool, accountPath string, w htt
 		}
 		return
 
-	case "/msg.js", "/text.js":
+	case "/i18n.json":
+		i18n.ServeJSON(log, w, r)
+		return
+
+	case "/proxy":
+		// Remote content for HTML messages, authenticated by a signature in the URL.
+		serveProxy(ctx, log, w, r)
+		return
+
+	case "/msg.js", "/text.js", "/sw.js":
 		switch r.Method {
 		default:
 			http.Error(w, "405 - method not allowed - use get", http.StatusMethodNotAllowed)
@@ -239,8 +253,13 @@ func handle(apiHandler http.Handler, isForwarded bool, accountPath string, w htt
 
 		path := filepath.Join("webmail", r.URL.Path[1:])
 		var fallback = webmailmsgJS
-		if r.URL.Path == "/text.js" {
+		switch r.URL.Path {
+		case "/text.js":
 			fallback = webmailtextJS
+		case "/sw.js":
+			// Service worker, for showing Web Push notifications.
+			fallback = webmailswJS
+			w.Header().Set("Cache-Control", "no-cache, max-age=0")
 		}
 
 		w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
@@ -255,18 +274,29 @@ func handle(apiHandler http.Handler, isForwarded bool, accountPath string, w htt
 		return
 	}
 
-	var loginAddress, accName string
+	var loginAddress, accName, sessionAccName string
 	var sessionToken store.SessionToken
 	// All other URLs, except the login endpoint require some authentication.
-	if r.URL.Path != "/api/LoginPrep" && r.URL.Path != "/api/Login" {
+	if r.URL.Path != "/api/LoginPrep" && r.URL.Path != "/api/Login" && r.URL.Path != "/api/PasskeyLoginPrep" && r.URL.Path != "/api/PasskeyLogin" && r.URL.Path != "/api/OIDCEnabled" && r.URL.Path != "/api/OIDCLoginPrep" && r.URL.Path != "/api/OIDCLogin" {
 		var ok bool
 		isExport := r.URL.Path == "/export"
-		requireCSRF := isAPI || isExport
+		isUpload := strings.HasPrefix(r.URL.Path, "/upload/") && r.Method == "PUT"
+		requireCSRF := isAPI || isExport || isUpload
 		accName, sessionToken, loginAddress, ok = webauth.Check(ctx, log, webauth.Accounts, "webmail", isForwarded, w, r, isAPI, requireCSRF, isExport)
 		if !ok {
 			// Response has been written already.
 			return
 		}
+
+		// Requests can be for an account linked to the session account.
+		sessionAccName = accName
+		var err error
+		accName, loginAddress, err = webauth.ActiveAccount(ctx, log, "webmail", r, accName, loginAddress)
+		if err != nil {
+			log.Errorx("looking up active account", err)
+			http.Error(w, "500 - internal server error - error looking up active account", http.StatusInternalServerError)
+			return
+		}
 	}
 
 	if isAPI {
@@ -285,7 +315,7 @@ func handle(apiHandler http.Handler, isForwarded bool, accountPath string, w htt
 				log.Check(err, "closing account")
 			}()
 		}
-		reqInfo := requestInfo{log, loginAddress, acc, sessionToken, w, r}
+		reqInfo := requestInfo{log, loginAddress, acc, sessionToken, sessionAccName, w, r}
 		ctx = context.WithValue(ctx, requestInfoCtxKey, reqInfo)
 		apiHandler.ServeHTTP(w, r.WithContext(ctx))
 		return
@@ -296,12 +326,18 @@ func handle(apiHandler http.Handler, isForwarded bool, accountPath string, w htt
 	// .../msg/<msgid>/{attachments.zip,parsedmessage.js,raw}
 	// .../msg/<msgid>/{,msg}{text,html,htmlexternal}
 	// .../msg/<msgid>/{view,viewtext,download}/<partid>
+	// .../upload/<uploadid>
 
 	if r.URL.Path == "/export" {
 		webops.Export(log, accName, w, r)
 		return
 	}
 
+	if strings.HasPrefix(r.URL.Path, "/upload/") {
+		handleUpload(log, accName, w, r)
+		return
+	}
+
 	if !strings.HasPrefix(r.URL.Path, "/msg/") {
 		http.NotFound(w, r)
 		return
@@ -396,7 +432,8 @@ func handle(apiHandler http.Handler, isForwarded bool, accountPath string, w htt
 	// fronts from external URLs as well as inline URI's. By default we don't allow any
 	// loading of content, except inlined images (we do that ourselves for images
 	// embedded in the email), and we allow inline styles (which are safely constrained
-	// to an iframe).
+	// to an iframe). If the remote content proxy is enabled, external URLs have been
+	// rewritten to the proxy and only loading from our own origin is allowed.
 	//
 	// If allowSelfScript is set, inline scripts and scripts from our origin are
 	// allowed. Used to display a message including header. The header is rendered with
@@ -417,7 +454,9 @@ func handle(apiHandler http.Handler, isForwarded bool, accountPath string, w htt
 			script = "; script-src 'unsafe-inline' 'self'; frame-src 'self'; connect-src 'self'"
 		}
 		var csp string
-		if allowExternal {
+		if allowExternal && proxyEnabled() {
+			csp = sb + "frame-ancestors 'self'; default-src 'none'; img-src data: 'self'; style-src 'unsafe-inline' data: 'self'; font-src data: 'self'; media-src data: 'self'" + script
+		} else if allowExternal {
 			csp = sb + "frame-ancestors 'self'; default-src 'none'; img-src data: http: https: 'unsafe-inline'; style-src 'unsafe-inline' data: http: https:; font-src data: http: https: 'unsafe-inline'; media-src 'unsafe-inline' data: http: https:" + script
 		} else if allowSelfImg {
 			csp = sb + "frame-ancestors 'self'; default-src 'none'; img-src data: 'self'; style-src 'unsafe-inline'" + script
@@ -571,8 +610,11 @@ func handle(apiHandler http.Handler, isForwarded bool, accountPath string, w htt
 		}
 		h.Set("Content-Type", mime.FormatMediaType(ct, params))
 		h.Set("Cache-Control", "no-store, max-age=0")
+		// With a known length, the response isn't chunked, and the message file can be
+		// sent without copying through userspace for plain HTTP.
+		h.Set("Content-Length", fmt.Sprintf("%d", msgr.Size()))
 
-		_, err := io.Copy(w, &moxio.AtReader{R: msgr})
+		_, err := io.Copy(w, msgr)
 		log.Check(err, "writing raw")
 
 	case len(t) == 2 && (t[1] == "msgtext" || t[1] == "msghtml" || t[1] == "msghtmlexternal"):
@@ -625,8 +667,8 @@ func handle(apiHandler http.Handler, isForwarded bool, accountPath string, w htt
 		pmjson, err := json.Marshal(pm)
 		xcheckf(ctx, err, "marshal parsedmessage")
 
-		m.MsgPrefix = nil
-		m.ParsedBuf = nil
+		m.Sealed.MsgPrefix = nil
+		m.Sealed.ParsedBuf = nil
 		mi := MessageItem{m, pm.envelope, pm.attachments, pm.isSigned, pm.isEncrypted, pm.firstLine, false}
 		mijson, err := json.Marshal(mi)
 		xcheckf(ctx, err, "marshal messageitem")
@@ -707,7 +749,12 @@ func handle(apiHandler http.Handler, isForwarded bool, accountPath string, w htt
 			switch mt {
 			case "TEXT/HTML":
 				done = true
-				err := inlineSanitizeHTML(log, setHeaders, w, p, parents)
+				var proxyPrefix string
+				if t[1] == "htmlexternal" && proxyEnabled() {
+					// Relative to msg/<id>/htmlexternal.
+					proxyPrefix = "../../"
+				}
+				err := inlineSanitizeHTML(log, setHeaders, w, p, parents, proxyPrefix)
 				if err != nil {
 					http.Error(w, "400 - bad request - "+err.Error(), http.StatusBadRequest)
 				}
@@ -776,6 +823,7 @@ func handle(apiHandler http.Handler, isForwarded bool, accountPath string, w htt
 			cd := mime.FormatMediaType("attachment", map[string]string{"filename": name})
 			h.Set("Content-Disposition", cd)
 		}
+		h.Set("Content-Length", fmt.Sprintf("%d", ap.DecodedSize))
 
 		_, err := io.Copy(w, ap.Reader())
 		if err != nil && !moxio.IsClosed(err) {
@@ -792,8 +840,10 @@ func handle(apiHandler http.Handler, isForwarded bool, accountPath string, w htt
 // scripts. If the HTML becomes too large, an error is returned. Before writing
 // HTML, setHeaders is called to write the required headers for content-type and
 // CSP. On error, setHeader is not called, no output is written and the caller
-// should write an error response.
-func inlineSanitizeHTML(log mlog.Log, setHeaders func(), w io.Writer, p *message.Part, parents []*message.Part) error {
+// should write an error response. If proxyPrefix is not empty, URLs of external
+// resources are rewritten to the remote content proxy, at proxyPrefix relative to
+// the HTML.
+func inlineSanitizeHTML(log mlog.Log, setHeaders func(), w io.Writer, p *message.Part, parents []*message.Part, proxyPrefix string) error {
 	// Prepare cids if there is a chance we will use them.
 	cids := map[string]*message.Part{}
 	for _, parent := range parents {
@@ -817,6 +867,9 @@ func inlineSanitizeHTML(log mlog.Log, setHeaders func(), w io.Writer, p *message
 		return fmt.Errorf("inline cid uris in html nodes: %w", err)
 	}
 	sanitizeNode(node)
+	if proxyPrefix != "" {
+		proxyRewriteHTML(node, proxyPrefix)
+	}
 	setHeaders()
 	err = html.Render(w, node)
 	log.Check(err, "writing html")
@@ -253,6 +253,19 @@ var (
 	}
 )
 
+var msgInvite = Message{
+	From:    "organizer <mjl+org@mox.example>",
+	To:      "mjl <mjl@mox.example>",
+	Subject: "Invitation: Planning",
+	Part: Part{
+		Type: "multipart/alternative",
+		Parts: []Part{
+			{Type: "text/plain", Content: "You are invited."},
+			{Type: "text/calendar; charset=utf-8; method=REQUEST", Content: "BEGIN:VCALENDAR\nVERSION:2.0\nPRODID:-//test//EN\nMETHOD:REQUEST\nBEGIN:VEVENT\nUID:invite1@mox.example\nDTSTAMP:20240101T120000Z\nDTSTART:20240110T090000Z\nDTEND:20240110T100000Z\nSUMMARY:Planning\nLOCATION:Room 1\nORGANIZER;CN=organizer:mailto:mjl+org@mox.example\nATTENDEE;CN=mjl;PARTSTAT=NEEDS-ACTION;RSVP=TRUE:mailto:mjl@mox.example\nEND:VEVENT\nEND:VCALENDAR\n"},
+		},
+	},
+}
+
 // Import test messages messages.
 type testmsg struct {
 	Mailbox  string
@@ -271,14 +284,16 @@ func tdeliver(t *testing.T, acc *store.Account, tm *testmsg) {
 	size, err := msgFile.Write(tm.msg.Marshal(t))
 	tcheck(t, err, "write message temp")
 	m := store.Message{
-		Flags:            tm.Flags,
-		RcptToLocalpart:  "mox",
-		RcptToDomain:     "other.example",
-		MsgFromLocalpart: "mjl",
-		MsgFromDomain:    "mox.example",
-		DKIMDomains:      []string{"mox.example"},
-		Keywords:         tm.Keywords,
-		Size:             int64(size),
+		Flags:         tm.Flags,
+		MsgFromDomain: "mox.example",
+		DKIMDomains:   []string{"mox.example"},
+		Keywords:      tm.Keywords,
+		Size:          int64(size),
+		Sealed: store.MessageSealed{
+			RcptToLocalpart:  "mox",
+			RcptToDomain:     "other.example",
+			MsgFromLocalpart: "mjl",
+		},
 	}
 	err = acc.DeliverMailbox(pkglog, tm.Mailbox, &m, msgFile)
 	tcheck(t, err, "deliver test message")
@@ -324,7 +339,7 @@ func TestWebmail(t *testing.T) {
 	tcheck(t, err, "sherpa handler")
 
 	respRec := httptest.NewRecorder()
-	reqInfo := requestInfo{log, "", nil, "", respRec, &http.Request{RemoteAddr: "127.0.0.1:1234"}}
+	reqInfo := requestInfo{log, "", nil, "", "", respRec, &http.Request{RemoteAddr: "127.0.0.1:1234"}}
 	ctx := context.WithValue(ctxbg, requestInfoCtxKey, reqInfo)
 
 	// Prepare loginToken.
@@ -332,7 +347,7 @@ func TestWebmail(t *testing.T) {
 	loginCookie.Value = api.LoginPrep(ctx)
 	reqInfo.Request.Header = http.Header{"Cookie": []string{loginCookie.String()}}
 
-	csrfToken := api.Login(ctx, loginCookie.Value, "mjl@mox.example", "test1234")
+	csrfToken := api.Login(ctx, loginCookie.Value, "mjl@mox.example", "test1234", "")
 	var sessionCookie *http.Cookie
 	for _, c := range respRec.Result().Cookies() {
 		if c.Name == "webmailsession" {
@@ -344,7 +359,7 @@ func TestWebmail(t *testing.T) {
 		t.Fatalf("missing session cookie")
 	}
 
-	reqInfo = requestInfo{log, "mjl@mox.example", acc, "", respRec, &http.Request{RemoteAddr: "127.0.0.1:1234"}}
+	reqInfo = requestInfo{log, "mjl@mox.example", acc, "", "mjl", respRec, &http.Request{RemoteAddr: "127.0.0.1:1234"}}
 	ctx = context.WithValue(ctxbg, requestInfoCtxKey, reqInfo)
 
 	tneedError(t, func() { api.MailboxCreate(ctx, "Inbox") })   // Cannot create inbox.
@@ -533,6 +548,23 @@ func TestWebmail(t *testing.T) {
 	testExport("mbox", "zip", "Lists", true, 3)
 	testExport("mbox", "zip", "Lists", false, 1)
 
+	// Uploads.
+	upload := store.Upload{Filename: "test.png", ContentType: "image/png", Size: 1}
+	err = acc.UploadAdd(ctxbg, pkglog, &upload)
+	tcheck(t, err, "add upload")
+	uploadPath := fmt.Sprintf("/upload/%d", upload.ID)
+	testHTTP("PUT", uploadPath+"?offset=0", httpHeaders{hdrSessionOK}, http.StatusForbidden, nil, nil) // Missing CSRF.
+	testHTTPAuthAPI("PUT", "/upload/0?offset=0", http.StatusNotFound, nil, nil)
+	testHTTPAuthAPI("PUT", fmt.Sprintf("/upload/%d?offset=0", upload.ID+1), http.StatusNotFound, nil, nil)
+	testHTTPAuthAPI("PUT", uploadPath, http.StatusBadRequest, nil, nil)                           // Missing offset.
+	testHTTPAuthAPI("PUT", uploadPath+"?offset=1", http.StatusConflict, httpHeaders{ctJSON}, nil) // Must resume at 0.
+	testHTTPAuthAPI("PUT", uploadPath+"?offset=0", http.StatusOK, httpHeaders{ctJSON}, nil)
+	testHTTPAuthREST("GET", uploadPath, http.StatusNotFound, nil, nil) // Not complete.
+	_, err = acc.UploadWrite(ctxbg, upload.ID, 0, []byte("x"))
+	tcheck(t, err, "write upload")
+	testHTTPAuthREST("GET", uploadPath, http.StatusOK, httpHeaders{{"Content-Type", "image/png"}}, nil)
+	testHTTPAuthREST("POST", uploadPath, http.StatusMethodNotAllowed, nil, nil)
+
 	// HTTP message, generic
 	testHTTP("GET", fmt.Sprintf("/msg/%v/attachments.zip", inboxMinimal.ID), nil, http.StatusForbidden, nil, nil)
 	testHTTP("GET", fmt.Sprintf("/msg/%v/attachments.zip", inboxMinimal.ID), httpHeaders{hdrCSRFBad}, http.StatusForbidden, nil, nil)
@@ -684,7 +716,7 @@ func TestWebmail(t *testing.T) {
 	// Normally the generic /api/ auth check returns a user error. We bypass it and
 	// check for the server error.
 	sessionToken := store.SessionToken(strings.SplitN(sessionCookie.Value, " ", 2)[0])
-	reqInfo = requestInfo{log, "mjl@mox.example", acc, sessionToken, httptest.NewRecorder(), &http.Request{RemoteAddr: "127.0.0.1:1234"}}
+	reqInfo = requestInfo{log, "mjl@mox.example", acc, sessionToken, "mjl", httptest.NewRecorder(), &http.Request{RemoteAddr: "127.0.0.1:1234"}}
 	ctx = context.WithValue(ctxbg, requestInfoCtxKey, reqInfo)
 	api.Logout(ctx)
 	tneedErrorCode(t, "server:error", func() { api.Logout(ctx) })
@@ -15,8 +15,12 @@ import (
 )
 
 // Export is used by webmail and webaccount to export messages of one or
-// multiple mailboxes, in maildir or mbox format, in a tar/tgz/zip archive or
-// direct mbox.
+// multiple mailboxes, in maildir, mbox or eml format, in a tar/tgz/zip archive or
+// direct mbox. The archive is streamed to the client while messages are read.
+//
+// Optional form fields "include" and "exclude" have newline-separated mailbox
+// patterns, "after" and "before" have dates in YYYY-MM-DD format, and "flags" and
+// "notflags" have space-separated flags.
 func Export(log mlog.Log, accName string, w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "405 - method not allowed - use post", http.StatusMethodNotAllowed)
@@ -28,7 +32,7 @@ func Export(log mlog.Log, accName string, w http.ResponseWriter, r *http.Request
 	archive := r.FormValue("archive")
 	recursive := r.FormValue("recursive") != ""
 	switch format {
-	case "maildir", "mbox":
+	case "maildir", "mbox", "eml":
 	default:
 		http.Error(w, "400 - bad request - unknown format", http.StatusBadRequest)
 		return
@@ -43,6 +47,11 @@ func Export(log mlog.Log, accName string, w http.ResponseWriter, r *http.Request
 		http.Error(w, "400 - bad request - archive none can only be used with non-recursive mbox", http.StatusBadRequest)
 		return
 	}
+	filter, err := exportFilter(r)
+	if err != nil {
+		http.Error(w, "400 - bad request - "+err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	acc, err := store.OpenAccount(log, accName)
 	if err != nil {
@@ -90,7 +99,81 @@ func Export(log mlog.Log, accName string, w http.ResponseWriter, r *http.Request
 		log.Check(err, "exporting mail close")
 	}()
 	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
-	if err := store.ExportMessages(r.Context(), log, acc.DB, acc.Dir, archiver, format == "maildir", mailbox, recursive); err != nil {
+	if err := store.ExportMessages(r.Context(), log, acc.DB, acc.Dir, archiver, store.ExportFormat(format), mailbox, recursive, filter); err != nil {
 		log.Errorx("exporting mail", err)
 	}
 }
+
+func exportFilter(r *http.Request) (store.ExportFilter, error) {
+	lines := func(s string) []string {
+		var l []string
+		for _, line := range strings.Split(s, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				l = append(l, line)
+			}
+		}
+		return l
+	}
+	date := func(k string) (time.Time, error) {
+		s := r.FormValue(k)
+		if s == "" {
+			return time.Time{}, nil
+		}
+		t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("bad %s date: %v", k, err)
+		}
+		return t, nil
+	}
+
+	f := store.ExportFilter{
+		Include:  lines(r.FormValue("include")),
+		Exclude:  lines(r.FormValue("exclude")),
+		Flags:    strings.Fields(r.FormValue("flags")),
+		NotFlags: strings.Fields(r.FormValue("notflags")),
+	}
+	var err error
+	if f.After, err = date("after"); err != nil {
+		return f, err
+	}
+	if f.Before, err = date("before"); err != nil {
+		return f, err
+	}
+	return f, f.Check()
+}
+
+// Takeout is used by webaccount and webadmin to send an archive with all data of
+// an account, as written by store.Takeout, as gzipped tar file. The archive is
+// streamed to the client.
+func Takeout(log mlog.Log, accName string, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "405 - method not allowed - use post", http.StatusMethodNotAllowed)
+		return
+	}
+
+	acc, err := store.OpenAccount(log, accName)
+	if err != nil {
+		log.Errorx("open account for takeout", err)
+		http.Error(w, "500 - internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	filename := fmt.Sprintf("takeout-%s-%s.tgz", accName, time.Now().Format("20060102-150405"))
+	// Don't tempt browsers to "helpfully" decompress.
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	gzw := gzip.NewWriter(w)
+	archiver := store.TarArchiver{Writer: tar.NewWriter(gzw)}
+	err = store.Takeout(r.Context(), log, acc, archiver)
+	if err == nil {
+		err = archiver.Close()
+	}
+	if err == nil {
+		err = gzw.Close()
+	}
+	log.Check(err, "writing takeout")
+}
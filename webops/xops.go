@@ -154,52 +154,22 @@ func (x XOps) MessageFlagsAdd(ctx context.Context, log mlog.Log, acc *store.Acco
 		var changes []store.Change
 
 		x.DBWrite(ctx, acc, func(tx *bstore.Tx) {
-			var modseq store.ModSeq
-			var retrain []store.Message
-			var mb, origmb store.Mailbox
-
-			for _, mid := range messageIDs {
-				m := x.messageID(ctx, tx, mid)
-
-				if mb.ID != m.MailboxID {
-					if mb.ID != 0 {
-						err := tx.Update(&mb)
-						x.Checkf(ctx, err, "updating mailbox")
-						if mb.MailboxCounts != origmb.MailboxCounts {
-							changes = append(changes, mb.ChangeCounts())
-						}
-						if mb.KeywordsChanged(origmb) {
-							changes = append(changes, mb.ChangeKeywords())
-						}
-					}
-					mb = x.mailboxID(ctx, tx, m.MailboxID)
-					origmb = mb
-				}
-				mb.Keywords, _ = store.MergeKeywords(mb.Keywords, keywords)
-
-				mb.Sub(m.MailboxCounts())
-				oflags := m.Flags
-				m.Flags = m.Flags.Set(flags, flags)
-				var kwChanged bool
-				m.Keywords, kwChanged = store.MergeKeywords(m.Keywords, keywords)
-				mb.Add(m.MailboxCounts())
+			_, changes = x.MessageFlagsAddTx(ctx, log, acc, tx, messageIDs, flags, keywords, 0)
+		})
 
-				if m.Flags == oflags && !kwChanged {
-					continue
-				}
+		store.BroadcastChanges(acc, changes)
+	})
+}
 
-				if modseq == 0 {
-					modseq, err = acc.NextModSeq(tx)
-					x.Checkf(ctx, err, "assigning next modseq")
-				}
-				m.ModSeq = modseq
-				err = tx.Update(&m)
-				x.Checkf(ctx, err, "updating message")
+func (x XOps) MessageFlagsAddTx(ctx context.Context, log mlog.Log, acc *store.Account, tx *bstore.Tx, messageIDs []int64, flags store.Flags, keywords []string, modseq store.ModSeq) (store.ModSeq, []store.Change) {
+	var retrain []store.Message
+	var changes []store.Change
+	var mb, origmb store.Mailbox
 
-				changes = append(changes, m.ChangeFlags(oflags))
-				retrain = append(retrain, m)
-			}
+	for _, mid := range messageIDs {
+		m := x.messageID(ctx, tx, mid)
 
+		if mb.ID != m.MailboxID {
 			if mb.ID != 0 {
 				err := tx.Update(&mb)
 				x.Checkf(ctx, err, "updating mailbox")
@@ -210,13 +180,49 @@ func (x XOps) MessageFlagsAdd(ctx context.Context, log mlog.Log, acc *store.Acco
 					changes = append(changes, mb.ChangeKeywords())
 				}
 			}
+			mb = x.mailboxID(ctx, tx, m.MailboxID)
+			origmb = mb
+		}
+		mb.Keywords, _ = store.MergeKeywords(mb.Keywords, keywords)
 
-			err = acc.RetrainMessages(ctx, log, tx, retrain, false)
-			x.Checkf(ctx, err, "retraining messages")
-		})
+		mb.Sub(m.MailboxCounts())
+		oflags := m.Flags
+		m.Flags = m.Flags.Set(flags, flags)
+		var kwChanged bool
+		m.Keywords, kwChanged = store.MergeKeywords(m.Keywords, keywords)
+		mb.Add(m.MailboxCounts())
+
+		if m.Flags == oflags && !kwChanged {
+			continue
+		}
 
-		store.BroadcastChanges(acc, changes)
-	})
+		if modseq == 0 {
+			var err error
+			modseq, err = acc.NextModSeq(tx)
+			x.Checkf(ctx, err, "assigning next modseq")
+		}
+		m.ModSeq = modseq
+		err := tx.Update(&m)
+		x.Checkf(ctx, err, "updating message")
+
+		changes = append(changes, m.ChangeFlags(oflags))
+		retrain = append(retrain, m)
+	}
+
+	if mb.ID != 0 {
+		err := tx.Update(&mb)
+		x.Checkf(ctx, err, "updating mailbox")
+		if mb.MailboxCounts != origmb.MailboxCounts {
+			changes = append(changes, mb.ChangeCounts())
+		}
+		if mb.KeywordsChanged(origmb) {
+			changes = append(changes, mb.ChangeKeywords())
+		}
+	}
+
+	err := acc.RetrainFlagChanges(ctx, log, tx, retrain)
+	x.Checkf(ctx, err, "retraining messages")
+	return modseq, changes
 }
 
 func (x XOps) MessageFlagsClear(ctx context.Context, log mlog.Log, acc *store.Account, messageIDs []int64, flaglist []string) {
@@ -224,67 +230,73 @@ func (x XOps) MessageFlagsClear(ctx context.Context, log mlog.Log, acc *store.Ac
 	x.Checkuserf(ctx, err, "parsing flags")
 
 	acc.WithRLock(func() {
-		var retrain []store.Message
 		var changes []store.Change
 
 		x.DBWrite(ctx, acc, func(tx *bstore.Tx) {
-			var modseq store.ModSeq
-			var mb, origmb store.Mailbox
-
-			for _, mid := range messageIDs {
-				m := x.messageID(ctx, tx, mid)
-
-				if mb.ID != m.MailboxID {
-					if mb.ID != 0 {
-						err := tx.Update(&mb)
-						x.Checkf(ctx, err, "updating counts for mailbox")
-						if mb.MailboxCounts != origmb.MailboxCounts {
-							changes = append(changes, mb.ChangeCounts())
-						}
-						// note: cannot remove keywords from mailbox by removing keywords from message.
-					}
-					mb = x.mailboxID(ctx, tx, m.MailboxID)
-					origmb = mb
-				}
-
-				oflags := m.Flags
-				mb.Sub(m.MailboxCounts())
-				m.Flags = m.Flags.Set(flags, store.Flags{})
-				var changed bool
-				m.Keywords, changed = store.RemoveKeywords(m.Keywords, keywords)
-				mb.Add(m.MailboxCounts())
+			_, changes = x.MessageFlagsClearTx(ctx, log, acc, tx, messageIDs, flags, keywords, 0)
+		})
 
-				if m.Flags == oflags && !changed {
-					continue
-				}
+		store.BroadcastChanges(acc, changes)
+	})
+}
 
-				if modseq == 0 {
-					modseq, err = acc.NextModSeq(tx)
-					x.Checkf(ctx, err, "assigning next modseq")
-				}
-				m.ModSeq = modseq
-				err = tx.Update(&m)
-				x.Checkf(ctx, err, "updating message")
+func (x XOps) MessageFlagsClearTx(ctx context.Context, log mlog.Log, acc *store.Account, tx *bstore.Tx, messageIDs []int64, flags store.Flags, keywords []string, modseq store.ModSeq) (store.ModSeq, []store.Change) {
+	var retrain []store.Message
+	var changes []store.Change
+	var mb, origmb store.Mailbox
 
-				changes = append(changes, m.ChangeFlags(oflags))
-				retrain = append(retrain, m)
-			}
+	for _, mid := range messageIDs {
+		m := x.messageID(ctx, tx, mid)
 
+		if mb.ID != m.MailboxID {
 			if mb.ID != 0 {
 				err := tx.Update(&mb)
-				x.Checkf(ctx, err, "updating keywords in mailbox")
+				x.Checkf(ctx, err, "updating counts for mailbox")
 				if mb.MailboxCounts != origmb.MailboxCounts {
 					changes = append(changes, mb.ChangeCounts())
 				}
 				// note: cannot remove keywords from mailbox by removing keywords from message.
 			}
+			mb = x.mailboxID(ctx, tx, m.MailboxID)
+			origmb = mb
+		}
 
-			err = acc.RetrainMessages(ctx, log, tx, retrain, false)
-			x.Checkf(ctx, err, "retraining messages")
-		})
+		oflags := m.Flags
+		mb.Sub(m.MailboxCounts())
+		m.Flags = m.Flags.Set(flags, store.Flags{})
+		var changed bool
+		m.Keywords, changed = store.RemoveKeywords(m.Keywords, keywords)
+		mb.Add(m.MailboxCounts())
 
-		store.BroadcastChanges(acc, changes)
-	})
+		if m.Flags == oflags && !changed {
+			continue
+		}
+
+		if modseq == 0 {
+			var err error
+			modseq, err = acc.NextModSeq(tx)
+			x.Checkf(ctx, err, "assigning next modseq")
+		}
+		m.ModSeq = modseq
+		err := tx.Update(&m)
+		x.Checkf(ctx, err, "updating message")
+
+		changes = append(changes, m.ChangeFlags(oflags))
+		retrain = append(retrain, m)
+	}
+
+	if mb.ID != 0 {
+		err := tx.Update(&mb)
+		x.Checkf(ctx, err, "updating keywords in mailbox")
+		if mb.MailboxCounts != origmb.MailboxCounts {
+			changes = append(changes, mb.ChangeCounts())
+		}
+		// note: cannot remove keywords from mailbox by removing keywords from message.
+	}
+
+	err := acc.RetrainFlagChanges(ctx, log, tx, retrain)
+	x.Checkf(ctx, err, "retraining messages")
+	return modseq, changes
 }
 
 // MessageMove moves messages to the mailbox represented by mailboxName, or to mailboxID if mailboxName is empty.
@@ -412,7 +424,7 @@ func (x XOps) MessageMoveTx(ctx context.Context, log mlog.Log, acc *store.Accoun
 	err = tx.Update(&mbDst)
 	x.Checkf(ctx, err, "updating mailbox with uidnext")
 
-	err = acc.RetrainMessages(ctx, log, tx, retrain, false)
+	err = acc.RetrainFlagChanges(ctx, log, tx, retrain)
 	x.Checkf(ctx, err, "retraining messages after move")
 
 	// Ensure UIDs of the removed message are in increasing order. It is quite common
@@ -0,0 +1,215 @@
+package webpush
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"time"
+
+	"github.com/mjl-/bstore"
+
+	"github.com/mjl-/mox/i18n"
+	"github.com/mjl-/mox/metrics"
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/store"
+)
+
+// Notification is the payload of a push message, shown as notification by the
+// webmail service worker.
+type Notification struct {
+	Title string
+	Body  string
+	URL   string // Relative to webmail, e.g. "#Inbox,123" for a message.
+	Tag   string // A notification replaces an earlier notification with the same tag.
+}
+
+// How long push services keep notifications for offline devices. New messages
+// are not news for long.
+const notificationTTL = 24 * time.Hour
+
+type accountChanges struct {
+	accName string
+	changes []store.Change
+}
+
+// Changes for accounts, from the change hook, processed in the background.
+var pending = make(chan accountChanges, 1000)
+
+// Start registers for changes to accounts, and sends notifications about new
+// unread messages in the Inbox to the push subscriptions of the account.
+func Start() {
+	log := mlog.New("webpush", nil)
+
+	store.SetChangeHook(func(accName string, changes []store.Change) {
+		// Called by the broadcaster, so only a quick check whether we may have to notify.
+		for _, c := range changes {
+			if ch, ok := c.(store.ChangeAddUID); ok && !ch.Flags.Seen && !ch.Flags.Junk {
+				select {
+				case pending <- accountChanges{accName, changes}:
+				default:
+					log.Info("too many pending changes, not sending web push notification", slog.String("account", accName))
+				}
+				return
+			}
+		}
+	})
+
+	go func() {
+		for {
+			select {
+			case ac := <-pending:
+				notifySafe(mox.Shutdown, log, ac.accName, ac.changes)
+			case <-mox.Shutdown.Done():
+				return
+			}
+		}
+	}()
+}
+
+func notifySafe(ctx context.Context, log mlog.Log, accName string, changes []store.Change) {
+	defer func() {
+		x := recover()
+		if x != nil {
+			log.Error("recover from panic", slog.Any("panic", x))
+			debug.PrintStack()
+			metrics.PanicInc(metrics.Webpush)
+		}
+	}()
+	notify(ctx, log, accName, changes)
+}
+
+// notify sends a notification about new messages in the Inbox to all push
+// subscriptions of the account.
+func notify(ctx context.Context, log mlog.Log, accName string, changes []store.Change) {
+	log = log.With(slog.String("account", accName))
+
+	acc, err := store.OpenAccount(log, accName)
+	if err != nil {
+		log.Errorx("open account for web push", err)
+		return
+	}
+	defer func() {
+		err := acc.Close()
+		log.Check(err, "closing account")
+	}()
+
+	subs, err := acc.PushSubscriptions(ctx)
+	if err != nil {
+		log.Errorx("listing push subscriptions", err)
+		return
+	} else if len(subs) == 0 {
+		return
+	}
+
+	var msgs []store.Message
+	var lang string
+	err = acc.DB.Read(ctx, func(tx *bstore.Tx) error {
+		inbox, err := bstore.QueryTx[store.Mailbox](tx).FilterNonzero(store.Mailbox{Name: "Inbox"}).Get()
+		if err != nil {
+			return fmt.Errorf("get inbox: %v", err)
+		}
+		for _, c := range changes {
+			ch, ok := c.(store.ChangeAddUID)
+			if !ok || ch.MailboxID != inbox.ID || ch.Flags.Seen || ch.Flags.Junk {
+				continue
+			}
+			q := bstore.QueryTx[store.Message](tx)
+			q.FilterNonzero(store.Message{MailboxID: ch.MailboxID, UID: ch.UID})
+			q.FilterEqual("Expunged", false)
+			m, err := q.Get()
+			if err == bstore.ErrAbsent {
+				continue
+			} else if err != nil {
+				return fmt.Errorf("get message: %v", err)
+			}
+			if !m.ThreadMuted {
+				msgs = append(msgs, m)
+			}
+		}
+		settings := store.Settings{ID: 1}
+		if err := tx.Get(&settings); err != nil {
+			return fmt.Errorf("get settings: %v", err)
+		}
+		lang = settings.Language
+		return nil
+	})
+	if err != nil {
+		log.Errorx("gathering new messages for web push", err)
+		return
+	} else if len(msgs) == 0 {
+		return
+	}
+
+	n := newNotification(log, lang, accName, msgs)
+	for _, sub := range subs {
+		err := Push(ctx, log, acc, sub, n)
+		log.Debugx("web push notification", err, slog.Int64("subscription", sub.ID))
+	}
+}
+
+// newNotification returns a notification about new messages.
+func newNotification(log mlog.Log, lang, accName string, msgs []store.Message) Notification {
+	last := msgs[len(msgs)-1]
+	var from, subject string
+	if p, err := last.LoadPart(nil); err != nil {
+		log.Debugx("loading message part for notification", err)
+	} else if p.Envelope != nil {
+		subject = p.Envelope.Subject
+		if len(p.Envelope.From) > 0 {
+			a := p.Envelope.From[0]
+			from = a.Name
+			if from == "" {
+				from = a.User + "@" + a.Host
+			}
+		}
+	}
+	subject = truncate(subject, 200)
+	from = truncate(from, 100)
+
+	n := Notification{
+		Title: from,
+		Body:  subject,
+		URL:   fmt.Sprintf("#Inbox,%d", last.ID),
+		Tag:   "newmail-" + accName,
+	}
+	if n.Title == "" {
+		n.Title = i18n.Translate(lang, "New message")
+	}
+	if len(msgs) > 1 {
+		n.Title = i18n.Translate(lang, "{0} new messages", len(msgs))
+		n.Body = from + ": " + subject
+		n.URL = "#Inbox"
+	}
+	return n
+}
+
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) > n {
+		return string(r[:n-1]) + "…"
+	}
+	return string(r)
+}
+
+// Push sends notification n to a subscription of the account, and records the
+// result with the subscription. Subscriptions that are gone are removed.
+func Push(ctx context.Context, log mlog.Log, acc *store.Account, sub store.PushSubscription, n Notification) error {
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return fmt.Errorf("marshal notification: %v", err)
+	}
+	sendErr := Send(ctx, log, sub, payload, notificationTTL)
+	if errors.Is(sendErr, ErrGone) {
+		log.Info("removing push subscription that is gone", slog.Int64("subscription", sub.ID))
+		err := acc.PushSubscriptionRemove(context.Background(), sub.ID)
+		log.Check(err, "removing push subscription")
+		return sendErr
+	}
+	err = acc.PushSubscriptionResult(context.Background(), sub.ID, sendErr)
+	log.Check(err, "storing result of push")
+	return sendErr
+}
@@ -0,0 +1,302 @@
+// Package webpush sends Web Push messages to browsers, for notifications about
+// new messages while webmail is not open.
+//
+// Messages are encrypted for the subscription of the browser (RFC 8291, with
+// content encoding aes128gcm of RFC 8188), and the server identifies itself to
+// the push service with VAPID (RFC 8292). The VAPID key pair is generated on first
+// use and stored in the data directory.
+package webpush
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/moxvar"
+	"github.com/mjl-/mox/store"
+)
+
+// ErrGone is returned by Send when the push service indicates the subscription
+// no longer exists. The subscription should be removed.
+var ErrGone = errors.New("subscription gone")
+
+// ErrSubscription is returned for an invalid subscription.
+var ErrSubscription = errors.New("invalid subscription")
+
+// Record size for the encrypted content, a single record for all payloads.
+const recordSize = 4096
+
+// MaxPayload is the maximum size of a payload that can be sent.
+const MaxPayload = recordSize - 16 - 1 - 86 // Tag, delimiter, header.
+
+var vapid struct {
+	sync.Mutex
+	key *ecdsa.PrivateKey
+}
+
+// vapidKey returns the VAPID key, reading it from the data directory, or
+// generating and storing a new key.
+func vapidKey() (*ecdsa.PrivateKey, error) {
+	vapid.Lock()
+	defer vapid.Unlock()
+	if vapid.key != nil {
+		return vapid.key, nil
+	}
+
+	p := mox.DataDirPath("webpush-vapid.key")
+	buf, err := os.ReadFile(p)
+	if err == nil {
+		b, _ := pem.Decode(buf)
+		if b == nil || b.Type != "PRIVATE KEY" {
+			return nil, fmt.Errorf("no private key in pem file %s", p)
+		}
+		k, err := x509.ParsePKCS8PrivateKey(b.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parsing vapid key: %v", err)
+		}
+		ek, ok := k.(*ecdsa.PrivateKey)
+		if !ok || ek.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("vapid key is not an ecdsa p-256 key")
+		}
+		vapid.key = ek
+		return ek, nil
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading vapid key: %v", err)
+	}
+
+	ek, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generating vapid key: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(ek)
+	if err != nil {
+		return nil, fmt.Errorf("marshal vapid key: %v", err)
+	}
+	buf = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(p, buf, 0660); err != nil {
+		return nil, fmt.Errorf("writing vapid key: %v", err)
+	}
+	vapid.key = ek
+	return ek, nil
+}
+
+// PublicKey returns the public VAPID key in uncompressed form, base64url-encoded,
+// to be passed as applicationServerKey when subscribing in the browser.
+func PublicKey() (string, error) {
+	k, err := vapidKey()
+	if err != nil {
+		return "", err
+	}
+	pk, err := k.PublicKey.ECDH()
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(pk.Bytes()), nil
+}
+
+func decodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// CheckSubscription checks the endpoint and keys of a subscription from a
+// browser.
+func CheckSubscription(endpoint, p256dh, auth string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("%w: endpoint must be an https url", ErrSubscription)
+	}
+	if buf, err := decodeBase64URL(p256dh); err != nil {
+		return fmt.Errorf("%w: decoding p256dh key: %v", ErrSubscription, err)
+	} else if _, err := ecdh.P256().NewPublicKey(buf); err != nil {
+		return fmt.Errorf("%w: parsing p256dh key: %v", ErrSubscription, err)
+	}
+	if buf, err := decodeBase64URL(auth); err != nil || len(buf) != 16 {
+		return fmt.Errorf("%w: auth secret must be 16 bytes", ErrSubscription)
+	}
+	return nil
+}
+
+// hkdf does HKDF extract and expand for a single block, RFC 5869.
+func hkdf(salt, ikm, info []byte, n int) []byte {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write(ikm)
+	prk := mac.Sum(nil)
+	mac = hmac.New(sha256.New, prk)
+	mac.Write(info)
+	mac.Write([]byte{1})
+	return mac.Sum(nil)[:n]
+}
+
+// encrypt encrypts plaintext for a browser with public key uaPublic and
+// authentication secret, using our ephemeral key asPrivate and salt, RFC 8291.
+func encrypt(uaPublic, authSecret []byte, asPrivate *ecdh.PrivateKey, salt, plaintext []byte) ([]byte, error) {
+	if len(plaintext) > MaxPayload {
+		return nil, fmt.Errorf("payload too large")
+	}
+	uaKey, err := ecdh.P256().NewPublicKey(uaPublic)
+	if err != nil {
+		return nil, fmt.Errorf("%w: parsing public key: %v", ErrSubscription, err)
+	}
+	ecdhSecret, err := asPrivate.ECDH(uaKey)
+	if err != nil {
+		return nil, fmt.Errorf("ecdh: %v", err)
+	}
+	asPublic := asPrivate.PublicKey().Bytes()
+
+	keyInfo := append([]byte("WebPush: info\x00"), uaPublic...)
+	keyInfo = append(keyInfo, asPublic...)
+	ikm := hkdf(authSecret, ecdhSecret, keyInfo, 32)
+	cek := hkdf(salt, ikm, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	nonce := hkdf(salt, ikm, []byte("Content-Encoding: nonce\x00"), 12)
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// Header: salt, record size, key id length and key id (our public key).
+	var b bytes.Buffer
+	b.Write(salt)
+	binary.Write(&b, binary.BigEndian, uint32(recordSize))
+	b.WriteByte(byte(len(asPublic)))
+	b.Write(asPublic)
+	// Single record, with delimiter 2 for the last record.
+	record := append(append([]byte{}, plaintext...), 2)
+	return gcm.Seal(b.Bytes(), nonce, record, nil), nil
+}
+
+// vapidAuthorization returns the value for the Authorization header for a request
+// to endpoint, RFC 8292.
+func vapidAuthorization(key *ecdsa.PrivateKey, endpoint string, now time.Time) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("parsing endpoint: %v", err)
+	}
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"typ":"JWT","alg":"ES256"}`))
+	claims, err := json.Marshal(map[string]any{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": now.Add(12 * time.Hour).Unix(),
+		"sub": "mailto:postmaster@" + mox.Conf.Static.HostnameDomain.ASCII,
+	})
+	if err != nil {
+		return "", err
+	}
+	data := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+	h := sha256.Sum256([]byte(data))
+	r, s, err := ecdsa.Sign(cryptorand.Reader, key, h[:])
+	if err != nil {
+		return "", fmt.Errorf("signing: %v", err)
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	pk, err := key.PublicKey.ECDH()
+	if err != nil {
+		return "", err
+	}
+	jwt := data + "." + base64.RawURLEncoding.EncodeToString(sig)
+	return fmt.Sprintf("vapid t=%s, k=%s", jwt, base64.RawURLEncoding.EncodeToString(pk.Bytes())), nil
+}
+
+// Endpoints are provided by users, the client refuses connections to internal
+// IPs, so push notifications can't be used to reach services on the local network.
+var client = &http.Client{
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: 10 * time.Second, Control: mox.PublicDialControl}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+		MaxIdleConns:        20,
+		IdleConnTimeout:     time.Minute,
+	},
+	Timeout: 30 * time.Second,
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return errors.New("redirects not allowed")
+	},
+}
+
+// Send encrypts payload for the subscription and delivers it to the push service
+// of the browser. The push service keeps the message for at most ttl while the
+// device is offline. ErrGone is returned if the subscription no longer exists.
+func Send(ctx context.Context, log mlog.Log, sub store.PushSubscription, payload []byte, ttl time.Duration) error {
+	key, err := vapidKey()
+	if err != nil {
+		return err
+	}
+	uaPublic, err := decodeBase64URL(sub.P256DH)
+	if err != nil {
+		return fmt.Errorf("%w: decoding p256dh key: %v", ErrSubscription, err)
+	}
+	authSecret, err := decodeBase64URL(sub.Auth)
+	if err != nil {
+		return fmt.Errorf("%w: decoding auth secret: %v", ErrSubscription, err)
+	}
+	asPrivate, err := ecdh.P256().GenerateKey(cryptorand.Reader)
+	if err != nil {
+		return fmt.Errorf("generating ephemeral key: %v", err)
+	}
+	salt := make([]byte, 16)
+	if _, err := cryptorand.Read(salt); err != nil {
+		return fmt.Errorf("generating salt: %v", err)
+	}
+	body, err := encrypt(uaPublic, authSecret, asPrivate, salt, payload)
+	if err != nil {
+		return err
+	}
+	authz, err := vapidAuthorization(key, sub.Endpoint, time.Now())
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("new request: %v", err)
+	}
+	req.Header.Set("User-Agent", fmt.Sprintf("mox/%s (webpush)", moxvar.Version))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("TTL", fmt.Sprintf("%d", int64(ttl/time.Second)))
+	req.Header.Set("Urgency", "normal")
+	req.Header.Set("Authorization", authz)
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("http transaction: %w", err)
+	}
+	defer resp.Body.Close()
+	// Push services respond with 201 Created.
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		return ErrGone
+	} else if resp.StatusCode/100 != 2 {
+		buf, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+		return fmt.Errorf("push service responded with status %d: %q", resp.StatusCode, buf)
+	}
+	log.Debug("web push sent", slog.Int("status", resp.StatusCode))
+	return nil
+}
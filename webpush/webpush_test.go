@@ -0,0 +1,243 @@
+package webpush
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mjl-/mox/mlog"
+	"github.com/mjl-/mox/mox-"
+	"github.com/mjl-/mox/store"
+)
+
+var ctxbg = context.Background()
+
+func tcheck(t *testing.T, err error, msg string) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %s", msg, err)
+	}
+}
+
+func tcompare(t *testing.T, got, exp any) {
+	t.Helper()
+	if got != exp {
+		t.Fatalf("got %v, expected %v", got, exp)
+	}
+}
+
+func b64(t *testing.T, s string) []byte {
+	t.Helper()
+	buf, err := base64.RawURLEncoding.DecodeString(s)
+	tcheck(t, err, "base64 decode")
+	return buf
+}
+
+// Example from RFC 8291, appendix A.
+func TestEncrypt(t *testing.T) {
+	asPrivate, err := ecdh.P256().NewPrivateKey(b64(t, "yfWPiYE-n46HLnH0KqZOF1fJJU3MYrct3AELtAQ-oRw"))
+	tcheck(t, err, "parse private key")
+	uaPublic := b64(t, "BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4")
+	authSecret := b64(t, "BTBZMqHH6r4Tts7J_aSIgg")
+	salt := b64(t, "DGv6ra1nlYgDCS1FRnbzlw")
+	body, err := encrypt(uaPublic, authSecret, asPrivate, salt, []byte("When I grow up, I want to be a watermelon"))
+	tcheck(t, err, "encrypt")
+	exp := "DGv6ra1nlYgDCS1FRnbzlwAAEABBBP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A_yl95bQpu6cVPTpK4Mqgkf1CXztLVBSt2Ks3oZwbuwXPXLWyouBWLVWGNWQexSgSxsj_Qulcy4a-fN"
+	tcompare(t, base64.RawURLEncoding.EncodeToString(body), exp)
+
+	_, err = encrypt(uaPublic, authSecret, asPrivate, salt, make([]byte, MaxPayload+1))
+	if err == nil {
+		t.Fatalf("expected error for too large payload")
+	}
+}
+
+// decrypt decrypts a message like a browser would.
+func decrypt(t *testing.T, uaPrivate *ecdh.PrivateKey, authSecret, body []byte) []byte {
+	t.Helper()
+	salt := body[:16]
+	rs := binary.BigEndian.Uint32(body[16:20])
+	tcompare(t, rs, uint32(recordSize))
+	asPublic, err := ecdh.P256().NewPublicKey(body[21 : 21+int(body[20])])
+	tcheck(t, err, "parse public key")
+	ecdhSecret, err := uaPrivate.ECDH(asPublic)
+	tcheck(t, err, "ecdh")
+	keyInfo := append([]byte("WebPush: info\x00"), uaPrivate.PublicKey().Bytes()...)
+	keyInfo = append(keyInfo, asPublic.Bytes()...)
+	ikm := hkdf(authSecret, ecdhSecret, keyInfo, 32)
+	block, err := aes.NewCipher(hkdf(salt, ikm, []byte("Content-Encoding: aes128gcm\x00"), 16))
+	tcheck(t, err, "aes")
+	gcm, err := cipher.NewGCM(block)
+	tcheck(t, err, "gcm")
+	buf, err := gcm.Open(nil, hkdf(salt, ikm, []byte("Content-Encoding: nonce\x00"), 12), body[21+int(body[20]):], nil)
+	tcheck(t, err, "decrypt")
+	tcompare(t, buf[len(buf)-1], byte(2))
+	return buf[:len(buf)-1]
+}
+
+// verifyVAPID checks the signature in the authorization header.
+func verifyVAPID(t *testing.T, authz string) {
+	t.Helper()
+	s, ok := strings.CutPrefix(authz, "vapid t=")
+	if !ok {
+		t.Fatalf("bad authorization header %q", authz)
+	}
+	jwt, k, ok := strings.Cut(s, ", k=")
+	if !ok {
+		t.Fatalf("missing key in authorization header %q", authz)
+	}
+	x, y := elliptic.Unmarshal(elliptic.P256(), b64(t, k))
+	if x == nil {
+		t.Fatalf("bad public key")
+	}
+	pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+	t1 := strings.Split(jwt, ".")
+	tcompare(t, len(t1), 3)
+	h := sha256.Sum256([]byte(t1[0] + "." + t1[1]))
+	sig := b64(t, t1[2])
+	tcompare(t, len(sig), 64)
+	if !ecdsa.Verify(pub, h[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		t.Fatalf("bad jwt signature")
+	}
+	var claims struct {
+		Aud string
+		Exp int64
+		Sub string
+	}
+	err := json.Unmarshal(b64(t, t1[1]), &claims)
+	tcheck(t, err, "parse claims")
+	tcompare(t, claims.Sub, "mailto:postmaster@mox.example")
+	if !strings.HasPrefix(claims.Aud, "https://") || claims.Exp <= time.Now().Unix() {
+		t.Fatalf("bad claims %#v", claims)
+	}
+}
+
+func TestSend(t *testing.T) {
+	os.RemoveAll("../testdata/webpush/data")
+	mox.ConfigStaticPath = filepath.FromSlash("../testdata/webpush/mox.conf")
+	mox.ConfigDynamicPath = filepath.FromSlash("../testdata/webpush/domains.conf")
+	mox.MustLoadConfig(true, false)
+	defer store.Switchboard()()
+	log := mlog.New("webpush", nil)
+
+	acc, err := store.OpenAccount(log, "mjl")
+	tcheck(t, err, "open account")
+	defer func() {
+		err := acc.Close()
+		tcheck(t, err, "close account")
+		acc.CheckClosed()
+	}()
+
+	uaPrivate, err := ecdh.P256().GenerateKey(cryptorand.Reader)
+	tcheck(t, err, "generate key")
+	authSecret := make([]byte, 16)
+	cryptorand.Read(authSecret)
+
+	status := http.StatusCreated
+	var payload []byte
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tcompare(t, r.Header.Get("Content-Encoding"), "aes128gcm")
+		tcompare(t, r.Header.Get("TTL"), "86400")
+		verifyVAPID(t, r.Header.Get("Authorization"))
+		body, err := io.ReadAll(r.Body)
+		tcheck(t, err, "read body")
+		payload = decrypt(t, uaPrivate, authSecret, body)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+	publicClient := client
+	client = srv.Client()
+	defer func() {
+		client = publicClient
+	}()
+
+	sub := store.PushSubscription{
+		Endpoint: srv.URL + "/push/1",
+		P256DH:   base64.RawURLEncoding.EncodeToString(uaPrivate.PublicKey().Bytes()),
+		Auth:     base64.RawURLEncoding.EncodeToString(authSecret),
+		Label:    "test",
+	}
+	err = CheckSubscription(sub.Endpoint, sub.P256DH, sub.Auth)
+	tcheck(t, err, "check subscription")
+	err = CheckSubscription("http://push.example", sub.P256DH, sub.Auth)
+	if !errors.Is(err, ErrSubscription) {
+		t.Fatalf("got %v, expected ErrSubscription", err)
+	}
+	err = acc.PushSubscriptionAdd(ctxbg, &sub)
+	tcheck(t, err, "add subscription")
+
+	n := Notification{Title: "test", Body: "body", URL: "#Inbox", Tag: "test"}
+	err = Push(ctxbg, log, acc, sub, n)
+	tcheck(t, err, "push")
+	var xn Notification
+	err = json.Unmarshal(payload, &xn)
+	tcheck(t, err, "parse payload")
+	tcompare(t, xn, n)
+
+	// The regular client refuses connections to internal IPs.
+	client = publicClient
+	err = Send(ctxbg, log, sub, []byte("test"), time.Hour)
+	if !errors.Is(err, mox.ErrSpecialPurposeIP) {
+		t.Fatalf("got %v, expected ErrSpecialPurposeIP", err)
+	}
+	client = srv.Client()
+	subs, err := acc.PushSubscriptions(ctxbg)
+	tcheck(t, err, "list subscriptions")
+	tcompare(t, len(subs), 1)
+	tcompare(t, subs[0].LastPush.IsZero(), false)
+
+	// The key is stored and reused.
+	pk, err := PublicKey()
+	tcheck(t, err, "public key")
+	vapid.key = nil
+	pk2, err := PublicKey()
+	tcheck(t, err, "public key")
+	tcompare(t, pk2, pk)
+
+	status = http.StatusInternalServerError
+	err = Push(ctxbg, log, acc, sub, n)
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+	subs, err = acc.PushSubscriptions(ctxbg)
+	tcheck(t, err, "list subscriptions")
+	tcompare(t, subs[0].LastError != "", true)
+
+	// Subscription is removed when gone.
+	status = http.StatusGone
+	err = Push(ctxbg, log, acc, sub, n)
+	if !errors.Is(err, ErrGone) {
+		t.Fatalf("got %v, expected ErrGone", err)
+	}
+	subs, err = acc.PushSubscriptions(ctxbg)
+	tcheck(t, err, "list subscriptions")
+	tcompare(t, len(subs), 0)
+
+	// Notifications about one and multiple new messages.
+	msgs := []store.Message{{ID: 1}, {ID: 2}}
+	xn = newNotification(log, "nl", "mjl", msgs[:1])
+	tcompare(t, xn.Title, "Nieuw bericht")
+	tcompare(t, xn.URL, "#Inbox,1")
+	xn = newNotification(log, "en", "mjl", msgs)
+	tcompare(t, xn.Title, "2 new messages")
+	tcompare(t, xn.URL, "#Inbox")
+	tcompare(t, truncate("abc", 2), "a…")
+	tcompare(t, truncate("abc", 3), "abc")
+}